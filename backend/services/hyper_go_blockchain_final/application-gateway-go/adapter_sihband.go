@@ -0,0 +1,43 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// sihBandAdapter reads the compact payload of the project's own band firmware:
+// {"ts": <unix seconds>, "lat", "lng", "acc", "bat", "hr", "sos": true}
+type sihBandAdapter struct {
+	ed25519Envelope
+	sosButton
+}
+
+func init() {
+	registerWearableAdapter("sihband", sihBandAdapter{})
+}
+
+func (sihBandAdapter) decode(payload []byte) (WearableTelemetry, error) {
+	var raw struct {
+		TS        int64    `json:"ts"`
+		Latitude  *float64 `json:"lat"`
+		Longitude *float64 `json:"lng"`
+		Accuracy  float64  `json:"acc"`
+		Battery   *int     `json:"bat"`
+		HeartRate *int     `json:"hr"`
+		SOS       bool     `json:"sos"`
+	}
+	if err := json.Unmarshal(payload, &raw); err != nil {
+		return WearableTelemetry{}, err
+	}
+	t := WearableTelemetry{Latitude: raw.Latitude, Longitude: raw.Longitude, Accuracy: raw.Accuracy, Battery: raw.Battery, HeartRate: raw.HeartRate, SOS: raw.SOS}
+	if raw.TS > 0 {
+		t.RecordedAt = time.Unix(raw.TS, 0).UTC().Format(time.RFC3339)
+	}
+	return t, nil
+}
@@ -0,0 +1,65 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// trackProAdapter reads the nested payload of TrackPro bands, which report a GPS fix flag and an
+// event name. A fix without "fix": true is ignored; HDOP is converted at five metres per unit.
+type trackProAdapter struct {
+	ed25519Envelope
+	sosButton
+}
+
+func init() {
+	registerWearableAdapter("trackpro", trackProAdapter{})
+}
+
+func (trackProAdapter) decode(payload []byte) (WearableTelemetry, error) {
+	var raw struct {
+		Timestamp string `json:"timestamp"`
+		GPS       *struct {
+			Fix       bool    `json:"fix"`
+			Latitude  float64 `json:"lat"`
+			Longitude float64 `json:"lon"`
+			HDOP      float64 `json:"hdop"`
+		} `json:"gps"`
+		Battery *struct {
+			Level *int `json:"level"`
+		} `json:"battery"`
+		Vitals *struct {
+			HeartRate *int `json:"heart_rate"`
+		} `json:"vitals"`
+		Event string `json:"event"`
+	}
+	if err := json.Unmarshal(payload, &raw); err != nil {
+		return WearableTelemetry{}, err
+	}
+	t := WearableTelemetry{SOS: strings.EqualFold(raw.Event, "SOS")}
+	if raw.Timestamp != "" {
+		recorded, err := time.Parse(time.RFC3339, raw.Timestamp)
+		if err != nil {
+			return t, fmt.Errorf("timestamp must be RFC3339")
+		}
+		t.RecordedAt = recorded.UTC().Format(time.RFC3339)
+	}
+	if raw.GPS != nil && raw.GPS.Fix {
+		t.Latitude, t.Longitude, t.Accuracy = &raw.GPS.Latitude, &raw.GPS.Longitude, raw.GPS.HDOP*5
+	}
+	if raw.Battery != nil {
+		t.Battery = raw.Battery.Level
+	}
+	if raw.Vitals != nil {
+		t.HeartRate = raw.Vitals.HeartRate
+	}
+	return t, nil
+}
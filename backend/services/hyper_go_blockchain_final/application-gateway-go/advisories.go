@@ -0,0 +1,646 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	advisoryAnchorPrefix = "ADV:"
+	maxAdvisoryBytes     = 1 << 20
+	maxFeedItems         = 100
+
+	advisoryCreated   = "created"
+	advisoryUpdated   = "updated"
+	advisoryUnchanged = "unchanged"
+	advisoryCancelled = "cancelled"
+	advisoryIgnored   = "ignored"
+)
+
+// capAlert is the part of a Common Alerting Protocol 1.2 message the gateway reads. IMD and NDMA's
+// SACHET platform publish their advisories as CAP.
+type capAlert struct {
+	XMLName    xml.Name  `xml:"alert"`
+	Identifier string    `xml:"identifier"`
+	Sender     string    `xml:"sender"`
+	Sent       string    `xml:"sent"`
+	Status     string    `xml:"status"`
+	MsgType    string    `xml:"msgType"`
+	References string    `xml:"references"`
+	Info       []capInfo `xml:"info"`
+}
+
+type capInfo struct {
+	Language string    `xml:"language"`
+	Event    string    `xml:"event"`
+	Severity string    `xml:"severity"`
+	Expires  string    `xml:"expires"`
+	Headline string    `xml:"headline"`
+	Areas    []capArea `xml:"area"`
+}
+
+// capArea carries polygons of "lat,lon" pairs and circles of "lat,lon radius" in kilometres
+type capArea struct {
+	Polygons []string `xml:"polygon"`
+	Circles  []string `xml:"circle"`
+}
+
+// capFeed is an RSS or Atom feed whose items link to CAP messages
+type capFeed struct {
+	Items []struct {
+		Link string `xml:"link"`
+	} `xml:"channel>item"`
+	Entries []struct {
+		Links []struct {
+			Href string `xml:"href,attr"`
+		} `xml:"link"`
+	} `xml:"entry"`
+}
+
+// AdvisoryIngestRequest names the feed a pushed CAP message came from
+type AdvisoryIngestRequest struct {
+	Source string `form:"source" binding:"required"`
+	Actor  string `form:"actor" binding:"required"`
+}
+
+func (r AdvisoryIngestRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.identifier("source", r.Source)
+	v.identifier("actor", r.Actor)
+	return v.errors
+}
+
+// AdvisoryIngestion reports what one CAP message did. Outcome is created, updated, unchanged,
+// cancelled or ignored, with the reason for ignoring it.
+type AdvisoryIngestion struct {
+	AlertID string        `json:"alert_id,omitempty"`
+	Outcome string        `json:"outcome"`
+	Reason  string        `json:"reason,omitempty"`
+	Alert   *WeatherAlert `json:"alert,omitempty"`
+}
+
+// AdvisoryAnchor is an advisory digest as the chaincode stores it
+type AdvisoryAnchor struct {
+	AnchorID   string   `json:"anchor_id"`
+	AdvisoryID string   `json:"advisory_id"`
+	Source     string   `json:"source"`
+	Digest     string   `json:"digest"`
+	Severity   string   `json:"severity"`
+	ZoneIDs    []string `json:"zone_ids"`
+	IssuedAt   string   `json:"issued_at"`
+	ExpiresAt  string   `json:"expires_at"`
+	AnchoredBy string   `json:"anchored_by"`
+	AnchoredAt string   `json:"anchored_at"`
+	TxID       string   `json:"tx_id"`
+}
+
+// advisoryFeed is a CAP message, or an RSS or Atom feed of them, polled from an agency
+type advisoryFeed struct {
+	source string
+	url    string
+}
+
+// advisoryIngester turns CAP advisories into weather alerts: it maps each to the zones it covers,
+// which raises their risk, anchors its digest and pushes it to the tourists inside those zones
+type advisoryIngester struct {
+	feeds       []advisoryFeed
+	interval    time.Duration
+	defaultTTL  time.Duration
+	notifyMin   int
+	actor       string
+	httpClient  *http.Client
+	maxFeedSize int64
+}
+
+var advisories *advisoryIngester
+
+// initAdvisories runs after initWeather. WEATHER_FEEDS is a list of source=url pairs.
+func initAdvisories() {
+	notifyMin := getEnv("WEATHER_NOTIFY_MIN_SEVERITY", "watch")
+	if weatherSeverity(notifyMin) < 0 {
+		panic(fmt.Errorf("WEATHER_NOTIFY_MIN_SEVERITY must be one of %s", strings.Join(weatherSeverities, ", ")))
+	}
+	a := &advisoryIngester{
+		interval:    getEnvDuration("WEATHER_FEED_INTERVAL", 5*time.Minute),
+		defaultTTL:  getEnvDuration("WEATHER_DEFAULT_TTL", 24*time.Hour),
+		notifyMin:   weatherSeverity(notifyMin),
+		actor:       getEnv("WEATHER_FEED_ACTOR", "weather-feed"),
+		httpClient:  &http.Client{Timeout: getEnvDuration("WEATHER_FEED_TIMEOUT", 15*time.Second)},
+		maxFeedSize: maxAdvisoryBytes,
+	}
+	for _, item := range splitList(getEnv("WEATHER_FEEDS", "")) {
+		source, url, ok := strings.Cut(item, "=")
+		if !ok || !identifierRegex.MatchString(strings.TrimSpace(source)) || !strings.HasPrefix(url, "http") {
+			panic(fmt.Errorf("WEATHER_FEEDS must be a list of source=url pairs, got %q", item))
+		}
+		a.feeds = append(a.feeds, advisoryFeed{source: strings.TrimSpace(source), url: strings.TrimSpace(url)})
+	}
+	advisories = a
+	if len(a.feeds) == 0 {
+		log.Println("🌦️ WEATHER_FEEDS not set, advisories only arrive through the API")
+		return
+	}
+	log.Printf("🌦️ Polling %d advisory feeds every %s", len(a.feeds), a.interval)
+}
+
+// capAlertID derives a stable alert ID from a CAP message's sender and identifier, which may hold
+// characters alert IDs cannot
+func capAlertID(sender, identifier string) string {
+	sum := sha256.Sum256([]byte(sender + "\n" + identifier))
+	return "CAP-" + hex.EncodeToString(sum[:10])
+}
+
+// capSeverity maps CAP severities onto IMD's colour-coded levels
+func capSeverity(severity string) string {
+	switch severity {
+	case "Extreme", "Severe":
+		return "warning"
+	case "Moderate":
+		return "watch"
+	default:
+		return "advisory"
+	}
+}
+
+// references lists the alert IDs of the messages this one updates or cancels
+func (a capAlert) references() []string {
+	var ids []string
+	for _, ref := range strings.Fields(a.References) {
+		parts := strings.Split(ref, ",")
+		if len(parts) == 3 {
+			ids = append(ids, capAlertID(parts[0], parts[1]))
+		}
+	}
+	return ids
+}
+
+// info picks the English info block, or the first one
+func (a capAlert) info() *capInfo {
+	for i := range a.Info {
+		if strings.HasPrefix(strings.ToLower(a.Info[i].Language), "en") {
+			return &a.Info[i]
+		}
+	}
+	if len(a.Info) > 0 {
+		return &a.Info[0]
+	}
+	return nil
+}
+
+// geometry combines every polygon and circle of the info's areas into one MultiPolygon
+func (info capInfo) geometry() (*GeoJSONGeometry, error) {
+	var polygons [][][][2]float64
+	for _, area := range info.Areas {
+		for _, polygon := range area.Polygons {
+			var ring zoneRing
+			for _, pair := range strings.Fields(polygon) {
+				lat, lng, err := parseCAPPoint(pair)
+				if err != nil {
+					return nil, err
+				}
+				ring = append(ring, [2]float64{lng, lat})
+			}
+			if len(ring) > 0 && ring[0] != ring[len(ring)-1] {
+				ring = append(ring, ring[0])
+			}
+			polygons = append(polygons, [][][2]float64{ring})
+		}
+		for _, circle := range area.Circles {
+			centre, radius, _ := strings.Cut(strings.TrimSpace(circle), " ")
+			lat, lng, err := parseCAPPoint(centre)
+			if err != nil {
+				return nil, err
+			}
+			km, err := strconv.ParseFloat(strings.TrimSpace(radius), 64)
+			if err != nil || km <= 0 {
+				return nil, fmt.Errorf("has a circle with an invalid radius %q", radius)
+			}
+			// Advisory circles can be wider than a zone circle may be, so they become polygons
+			outline := (&indexedZone{circle: &zoneCircle{lng: lng, lat: lat, radius: km * 1000}}).outline()
+			polygons = append(polygons, [][][2]float64{outline[0]})
+		}
+	}
+	if len(polygons) == 0 {
+		return nil, fmt.Errorf("has no polygon or circle")
+	}
+	geometry := &GeoJSONGeometry{Type: "MultiPolygon", Coordinates: polygons}
+	if _, _, err := parseZoneGeometry(*geometry); err != nil {
+		return nil, fmt.Errorf("geometry %w", err)
+	}
+	return geometry, nil
+}
+
+func parseCAPPoint(pair string) (float64, float64, error) {
+	latText, lngText, ok := strings.Cut(pair, ",")
+	lat, latErr := strconv.ParseFloat(latText, 64)
+	lng, lngErr := strconv.ParseFloat(lngText, 64)
+	if !ok || latErr != nil || lngErr != nil {
+		return 0, 0, fmt.Errorf("has an invalid point %q", pair)
+	}
+	return lat, lng, nil
+}
+
+// ingest applies one CAP message. Updates and cancellations withdraw the alerts they reference. A
+// message already ingested is left alone once its digest is anchored, so feeds can be polled
+// repeatedly; its tourists are only notified the first time.
+func (a *advisoryIngester) ingest(ctx context.Context, source string, raw []byte) (AdvisoryIngestion, error) {
+	var msg capAlert
+	if err := xml.Unmarshal(raw, &msg); err != nil || msg.Identifier == "" || msg.Sender == "" {
+		return AdvisoryIngestion{}, ValidationErrors{{Field: "body", Message: "must be a CAP 1.2 alert with an identifier and sender"}}
+	}
+	sum := sha256.Sum256(raw)
+	digest := hex.EncodeToString(sum[:])
+	result := AdvisoryIngestion{AlertID: capAlertID(msg.Sender, msg.Identifier)}
+	if msg.Status != "Actual" {
+		result.Outcome, result.Reason = advisoryIgnored, "status is "+msg.Status
+		return result, nil
+	}
+
+	withdrawn := false
+	for _, id := range msg.references() {
+		if id == result.AlertID {
+			continue
+		}
+		removed, err := weatherAlerts.remove(ctx, id)
+		if err != nil {
+			return result, err
+		}
+		withdrawn = withdrawn || removed
+	}
+	if msg.MsgType == "Cancel" {
+		result.Outcome = advisoryCancelled
+		if _, err := weatherAlerts.remove(ctx, result.AlertID); err != nil {
+			return result, err
+		}
+		return result, refreshZoneRaises(ctx)
+	}
+	if withdrawn {
+		if err := refreshZoneRaises(ctx); err != nil {
+			return result, err
+		}
+	}
+
+	info := msg.info()
+	if info == nil {
+		result.Outcome, result.Reason = advisoryIgnored, "no info block"
+		return result, nil
+	}
+	geometry, err := info.geometry()
+	if err != nil {
+		result.Outcome, result.Reason = advisoryIgnored, "area "+err.Error()
+		return result, nil
+	}
+	now := time.Now().UTC()
+	expires, err := time.Parse(time.RFC3339, info.Expires)
+	if err != nil {
+		expires = now.Add(a.defaultTTL)
+	}
+	expires = minTime(expires, now.Add(maxWeatherAlertDuration))
+	if !expires.After(now) {
+		result.Outcome, result.Reason = advisoryIgnored, "expired"
+		return result, nil
+	}
+
+	existing, err := findWeatherAlert(ctx, result.AlertID)
+	if err != nil {
+		return result, err
+	}
+	if existing != nil && existing.Digest == digest && existing.AnchorTxID != "" {
+		result.Outcome, result.Alert = advisoryUnchanged, existing
+		return result, nil
+	}
+
+	headline := info.Headline
+	if headline == "" {
+		headline = info.Event
+	}
+	alert := WeatherAlert{
+		AlertID:   result.AlertID,
+		Severity:  capSeverity(info.Severity),
+		Headline:  truncateRunes(headline, maxWeatherHeadline),
+		Geometry:  *geometry,
+		ExpiresAt: expires.UTC().Format(time.RFC3339),
+		UpdatedAt: now.Format(time.RFC3339),
+		Source:    source,
+		Reference: msg.Identifier,
+		Digest:    digest,
+	}
+	mapWeatherZones(&alert)
+
+	issued, err := time.Parse(time.RFC3339, msg.Sent)
+	if err != nil {
+		issued = now
+	}
+	if alert.AnchorTxID, err = a.anchor(withTarget(ctx, defaultTarget), alert, issued); err != nil {
+		// The alert still takes effect; the next poll retries the anchor
+		logWithContext(ctx, "🌦️ Failed to anchor advisory %s: %v", alert.AlertID, err)
+	}
+	if existing != nil && existing.Digest == digest {
+		alert.Notified = existing.Notified
+	} else {
+		alert.Notified = a.notify(ctx, alert)
+	}
+
+	if err := weatherAlerts.put(ctx, alert); err != nil {
+		return result, err
+	}
+	result.Outcome, result.Alert = advisoryCreated, &alert
+	if existing != nil {
+		result.Outcome = advisoryUpdated
+	}
+	return result, refreshZoneRaises(ctx)
+}
+
+// anchor records the advisory's digest and zones on the ledger. Anchor IDs are derived from the
+// digest, so a digest anchored by another instance is read back instead.
+func (a *advisoryIngester) anchor(ctx context.Context, alert WeatherAlert, issued time.Time) (string, error) {
+	anchorID := advisoryAnchorPrefix + alert.Digest
+	zoneIDs, err := json.Marshal(append([]string{}, alert.ZoneIDs...))
+	if err != nil {
+		return "", err
+	}
+	result, err := submitTransaction(ctx, "AnchorAdvisory", anchorID, alert.AlertID, alert.Source, alert.Digest, alert.Severity, string(zoneIDs), issued.UTC().Format(time.RFC3339), alert.ExpiresAt, a.actor)
+	if err == nil {
+		return result.TxID, nil
+	}
+	if translateFabricError(err).Code != errCodeAlreadyExists {
+		return "", err
+	}
+	payload, err := evaluateTransaction(ctx, "ReadAdvisoryAnchor", anchorID)
+	if err != nil {
+		return "", err
+	}
+	anchor, err := decodeDocument[AdvisoryAnchor](payload, "advisory anchor")
+	if err != nil {
+		return "", err
+	}
+	return anchor.TxID, nil
+}
+
+// notify pushes the alert to the apps of the tourists inside its zones, at or above
+// WEATHER_NOTIFY_MIN_SEVERITY, and returns how many tourists it addressed. With proximity
+// broadcasts enabled it reaches every tourist recently seen inside the advisory's own area instead.
+func (a *advisoryIngester) notify(ctx context.Context, alert WeatherAlert) int {
+	if pusher == nil || weatherSeverity(alert.Severity) < a.notifyMin {
+		return 0
+	}
+	if broadcasts != nil {
+		result, err := broadcasts.advisory(ctx, alert, a.actor)
+		if err != nil {
+			logWithContext(ctx, "🌦️ Failed to broadcast advisory %s: %v", alert.AlertID, err)
+			return 0
+		}
+		return result.Broadcast.Recipients
+	}
+	if zoneTracker == nil {
+		return 0
+	}
+	tourists := map[string]bool{}
+	for _, zoneID := range alert.ZoneIDs {
+		occupants, err := zoneTracker.store.occupants(ctx, zoneID)
+		if err != nil {
+			logWithContext(ctx, "🌦️ Failed to list tourists in zone %s: %v", zoneID, err)
+			continue
+		}
+		for _, digitalID := range occupants {
+			tourists[digitalID] = true
+		}
+	}
+	if len(tourists) == 0 {
+		return 0
+	}
+	go pusher.deliver(context.WithoutCancel(ctx), pushAlert{
+		key:      "weather:" + alert.Digest,
+		tourists: tourists,
+		title:    "Weather " + alert.Severity + " for your area",
+		body:     alert.Headline,
+		data:     map[string]string{"type": "weather", "alert_id": alert.AlertID, "severity": alert.Severity, "expires_at": alert.ExpiresAt},
+	})
+	return len(tourists)
+}
+
+// run refreshes zone risk raises every WEATHER_FEED_INTERVAL and polls the feeds. Each window is
+// claimed, so with several gateway instances only one polls it.
+func (a *advisoryIngester) run(ctx context.Context) {
+	for {
+		if err := refreshZoneRaises(ctx); err != nil {
+			log.Printf("Failed to refresh zone risk raises: %v", err)
+		}
+		window := time.Now().UTC().Truncate(a.interval)
+		if len(a.feeds) > 0 {
+			if claimed, err := claimEvent(ctx, "weather-feed:"+window.Format(time.RFC3339), a.interval); err == nil && claimed {
+				a.poll(ctx)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(window.Add(a.interval))):
+		}
+	}
+}
+
+func (a *advisoryIngester) poll(ctx context.Context) {
+	for _, feed := range a.feeds {
+		ingested, err := a.pollFeed(ctx, feed)
+		if err != nil {
+			log.Printf("Failed to poll advisory feed %s: %v", feed.source, err)
+		}
+		if ingested > 0 {
+			log.Printf("🌦️ Ingested %d new or updated advisories from %s", ingested, feed.source)
+		}
+	}
+}
+
+// pollFeed ingests a CAP message, or each CAP message an RSS or Atom feed links to
+func (a *advisoryIngester) pollFeed(ctx context.Context, feed advisoryFeed) (int, error) {
+	body, err := a.fetch(ctx, feed.url)
+	if err != nil {
+		return 0, err
+	}
+	links, isFeed := capFeedLinks(body)
+	if !isFeed {
+		// The URL is a CAP message itself
+		links = []string{""}
+	}
+
+	ingested := 0
+	var errs []error
+	for i, link := range links {
+		if i == maxFeedItems {
+			break
+		}
+		raw := body
+		if link != "" {
+			if raw, err = a.fetch(ctx, link); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+		}
+		result, err := a.ingest(ctx, feed.source, raw)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", link, err))
+			continue
+		}
+		if result.Outcome == advisoryCreated || result.Outcome == advisoryUpdated || result.Outcome == advisoryCancelled {
+			ingested++
+		}
+	}
+	return ingested, errors.Join(errs...)
+}
+
+// capFeedLinks returns the links of an RSS or Atom feed, or false when the document is not a feed
+func capFeedLinks(body []byte) ([]string, bool) {
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, false
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			if start.Name.Local != "rss" && start.Name.Local != "feed" {
+				return nil, false
+			}
+			break
+		}
+	}
+	var feed capFeed
+	if xml.Unmarshal(body, &feed) != nil {
+		return nil, false
+	}
+	var links []string
+	for _, item := range feed.Items {
+		if link := strings.TrimSpace(item.Link); link != "" {
+			links = append(links, link)
+		}
+	}
+	for _, entry := range feed.Entries {
+		if len(entry.Links) > 0 && entry.Links[0].Href != "" {
+			links = append(links, entry.Links[0].Href)
+		}
+	}
+	return links, true
+}
+
+func (a *advisoryIngester) fetch(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/cap+xml, application/rss+xml, application/atom+xml, application/xml;q=0.9")
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, a.maxFeedSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > a.maxFeedSize {
+		return nil, fmt.Errorf("%s is larger than %d bytes", url, a.maxFeedSize)
+	}
+	return body, nil
+}
+
+func findWeatherAlert(ctx context.Context, alertID string) (*WeatherAlert, error) {
+	alerts, err := weatherAlerts.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := range alerts {
+		if alerts[i].AlertID == alertID {
+			return &alerts[i], nil
+		}
+	}
+	return nil, nil
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+func truncateRunes(s string, limit int) string {
+	if utf8.RuneCountInString(s) <= limit {
+		return s
+	}
+	return string([]rune(s)[:limit])
+}
+
+func (ledgerService) AdvisoryAnchors(ctx context.Context, advisoryID string) ([]AdvisoryAnchor, error) {
+	result, err := evaluateTransaction(ctx, "GetAdvisoryAnchors", advisoryID)
+	if err != nil {
+		return nil, err
+	}
+	return decodeDocument[[]AdvisoryAnchor](result, "advisory anchor")
+}
+
+// ingestAdvisory accepts a CAP message pushed by an agency or an operator
+func ingestAdvisory(c *gin.Context) {
+	var req AdvisoryIngestRequest
+	if !bindQuery(c, &req) {
+		return
+	}
+	if c.Request.ContentLength > maxAdvisoryBytes {
+		respondError(c, http.StatusRequestEntityTooLarge, errCodePayloadTooLarge, fmt.Sprintf("The advisory must not exceed %d bytes", maxAdvisoryBytes))
+		return
+	}
+	raw, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxAdvisoryBytes))
+	if err != nil {
+		respondError(c, http.StatusRequestEntityTooLarge, errCodePayloadTooLarge, fmt.Sprintf("The advisory must not exceed %d bytes", maxAdvisoryBytes))
+		return
+	}
+
+	ingester := *advisories
+	ingester.actor = req.Actor
+	result, err := ingester.ingest(c.Request.Context(), req.Source, raw)
+	if result.AlertID != "" {
+		setAuditTarget(c, result.AlertID)
+	}
+	if err != nil {
+		respondServiceError(c, "Failed to ingest advisory", err)
+		return
+	}
+	respondData(c, http.StatusOK, result)
+}
+
+// listAdvisoryAnchors lists every anchored version of an advisory
+func listAdvisoryAnchors(c *gin.Context) {
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	anchors, err := ledger.AdvisoryAnchors(c.Request.Context(), id)
+	if err != nil {
+		respondServiceError(c, "Failed to list advisory anchors", err)
+		return
+	}
+	respondData(c, http.StatusOK, anchors)
+}
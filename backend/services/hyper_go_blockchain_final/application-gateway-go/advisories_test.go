@@ -0,0 +1,183 @@
+package gateway
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func testCAPAlert(identifier, msgType, references, expires string) []byte {
+	return []byte(fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<alert xmlns="urn:oasis:names:tc:emergency:cap:1.2">
+  <identifier>%s</identifier>
+  <sender>imd.gov.in</sender>
+  <sent>2026-07-01T10:00:00+05:30</sent>
+  <status>Actual</status>
+  <msgType>%s</msgType>
+  <references>%s</references>
+  <info>
+    <language>hi-IN</language>
+    <event>भारी वर्षा</event>
+    <severity>Severe</severity>
+  </info>
+  <info>
+    <language>en-IN</language>
+    <event>Heavy Rainfall</event>
+    <severity>Severe</severity>
+    <expires>%s</expires>
+    <headline>Heavy to very heavy rainfall over East Khasi Hills</headline>
+    <area>
+      <areaDesc>East Khasi Hills</areaDesc>
+      <polygon>25.50,91.80 25.50,91.90 25.60,91.90 25.60,91.80</polygon>
+      <circle>25.58,91.95 2</circle>
+    </area>
+  </info>
+</alert>`, identifier, msgType, references, expires))
+}
+
+func TestCAPAlertParsing(t *testing.T) {
+	var msg capAlert
+	if err := xml.Unmarshal(testCAPAlert("IMD-42", "Update", "imd.gov.in,IMD-41,2026-07-01T06:00:00+05:30", "2026-07-02T10:00:00+05:30"), &msg); err != nil {
+		t.Fatal(err)
+	}
+	info := msg.info()
+	if info == nil || info.Language != "en-IN" || capSeverity(info.Severity) != "warning" {
+		t.Fatalf("expected the English info block at warning, got %+v", info)
+	}
+	if refs := msg.references(); len(refs) != 1 || refs[0] != capAlertID("imd.gov.in", "IMD-41") {
+		t.Errorf("expected the referenced alert's ID, got %v", refs)
+	}
+
+	geometry, err := info.geometry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if geometry.Type != "MultiPolygon" || len(geometry.Coordinates.([][][][2]float64)) != 2 {
+		t.Fatalf("expected the polygon and circle combined, got %+v", geometry)
+	}
+	polygon := geometry.Coordinates.([][][][2]float64)[0][0]
+	if len(polygon) != 5 || polygon[0] != [2]float64{91.80, 25.50} || polygon[4] != polygon[0] {
+		t.Errorf("expected a closed lng,lat ring, got %v", polygon)
+	}
+
+	for name, area := range map[string]capArea{
+		"empty":      {},
+		"bad point":  {Polygons: []string{"25.5;91.8 25.5,91.9 25.6,91.9"}},
+		"bad radius": {Circles: []string{"25.5,91.8 wide"}},
+	} {
+		if _, err := (capInfo{Areas: []capArea{area}}).geometry(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestCAPFeedLinks(t *testing.T) {
+	rss := []byte(`<rss version="2.0"><channel><item><link>https://sachet.ndma.gov.in/cap/1.xml</link></item>` +
+		`<item><link> https://sachet.ndma.gov.in/cap/2.xml </link></item></channel></rss>`)
+	if links, ok := capFeedLinks(rss); !ok || len(links) != 2 || links[1] != "https://sachet.ndma.gov.in/cap/2.xml" {
+		t.Errorf("expected two RSS links, got %v %v", links, ok)
+	}
+	atom := []byte(`<feed xmlns="http://www.w3.org/2005/Atom"><entry><link href="https://mausam.imd.gov.in/cap/3.xml"/></entry></feed>`)
+	if links, ok := capFeedLinks(atom); !ok || len(links) != 1 || links[0] != "https://mausam.imd.gov.in/cap/3.xml" {
+		t.Errorf("expected one Atom link, got %v %v", links, ok)
+	}
+	if _, ok := capFeedLinks(testCAPAlert("IMD-1", "Alert", "", "")); ok {
+		t.Error("expected a CAP message not read as a feed")
+	}
+}
+
+func TestAdvisoryIngestWithoutLedger(t *testing.T) {
+	previousWeather := weatherAlerts
+	weatherAlerts = newMemoryWeatherStore()
+	defer func() { weatherAlerts = previousWeather }()
+	ctx := context.Background()
+	ingester := &advisoryIngester{defaultTTL: time.Hour, actor: "weather-feed"}
+
+	if _, err := ingester.ingest(ctx, "imd", []byte("<html/>")); err == nil {
+		t.Error("expected a document that is not CAP rejected")
+	}
+	result, err := ingester.ingest(ctx, "imd", testCAPAlert("IMD-1", "Alert", "", "2000-01-01T00:00:00Z"))
+	if err != nil || result.Outcome != advisoryIgnored || result.Reason != "expired" {
+		t.Errorf("expected an expired advisory ignored, got %+v %v", result, err)
+	}
+
+	// A cancellation withdraws the alert it references, without touching the ledger
+	expires := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	original := capAlertID("imd.gov.in", "IMD-1")
+	weatherAlerts.put(ctx, WeatherAlert{AlertID: original, Severity: "warning", ExpiresAt: expires})
+	result, err = ingester.ingest(ctx, "imd", testCAPAlert("IMD-2", "Cancel", "imd.gov.in,IMD-1,2026-07-01T10:00:00+05:30", expires))
+	if err != nil || result.Outcome != advisoryCancelled {
+		t.Fatalf("expected a cancellation, got %+v %v", result, err)
+	}
+	if alert, _ := findWeatherAlert(ctx, original); alert != nil {
+		t.Errorf("expected the referenced alert withdrawn, got %+v", alert)
+	}
+}
+
+func TestWeatherRaisesZoneRisk(t *testing.T) {
+	geofence = newGeofenceIndex()
+	previousWeather := weatherAlerts
+	weatherAlerts = newMemoryWeatherStore()
+	weatherRaises = map[string]string{"warning": "high", "watch": "medium"}
+	defer func() { geofence, weatherAlerts, weatherRaises = nil, previousWeather, nil }()
+	ctx := context.Background()
+
+	park := `{"type":"Polygon","coordinates":[[[91.80,25.50],[91.90,25.50],[91.90,25.60],[91.80,25.60],[91.80,25.50]]]}`
+	lake := `{"type":"Circle","coordinates":[91.97,25.58],"radius":1000}`
+	far := `{"type":"Polygon","coordinates":[[[92.50,26.50],[92.60,26.50],[92.60,26.60],[92.50,26.50]]]}`
+	for _, doc := range []ZoneDocument{
+		{ZoneID: "park", Name: "Park", Geometry: park, RiskLevel: "low"},
+		{ZoneID: "lake", Name: "Lake", Geometry: lake, RiskLevel: "medium"},
+		{ZoneID: "far", Name: "Far", Geometry: far, RiskLevel: "low"},
+	} {
+		geofenceFromEvent(ctx, zoneEvent(t, "CreateZone", doc))
+	}
+
+	var msg capAlert
+	xml.Unmarshal(testCAPAlert("IMD-1", "Alert", "", ""), &msg)
+	geometry, err := msg.info().geometry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	alert := WeatherAlert{AlertID: "CAP-1", Severity: "warning", Geometry: *geometry, ExpiresAt: time.Now().Add(time.Hour).UTC().Format(time.RFC3339)}
+	mapWeatherZones(&alert)
+	if len(alert.ZoneIDs) != 2 || alert.ZoneIDs[0] != "lake" || alert.ZoneIDs[1] != "park" || alert.RaisedRisk != "high" {
+		t.Fatalf("expected the lake and park raised to high, got %v %s", alert.ZoneIDs, alert.RaisedRisk)
+	}
+
+	weatherAlerts.put(ctx, alert)
+	if err := refreshZoneRaises(ctx); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	got := geofence.evaluate(25.55, 91.85, now)
+	if got.RiskLevel != "high" || len(got.Zones) != 1 || got.Zones[0].RaisedBy != "CAP-1" {
+		t.Errorf("expected the park raised by the alert, got %+v", got)
+	}
+	if later := geofence.evaluate(25.55, 91.85, now.Add(2*time.Hour)); later.RiskLevel != "low" {
+		t.Errorf("expected the raise to lapse with the alert, got %+v", later)
+	}
+	if other := geofence.evaluate(26.52, 92.58, now); other.RiskLevel != "low" {
+		t.Errorf("expected the far zone untouched, got %+v", other)
+	}
+
+	weatherAlerts.remove(ctx, "CAP-1")
+	refreshZoneRaises(ctx)
+	if got := geofence.evaluate(25.55, 91.85, now); got.RiskLevel != "low" {
+		t.Errorf("expected the raise withdrawn with the alert, got %+v", got)
+	}
+}
+
+func TestPresenceOccupants(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryPresenceStore(time.Hour)
+	store.save(ctx, "did:sih:b", zonePresence{Zones: map[string]zoneVisit{"park": {}}})
+	store.save(ctx, "did:sih:a", zonePresence{Zones: map[string]zoneVisit{"park": {}, "lake": {}}})
+	store.save(ctx, "did:sih:c", zonePresence{Zones: map[string]zoneVisit{}})
+	inside, err := store.occupants(ctx, "park")
+	if err != nil || len(inside) != 2 || inside[0] != "did:sih:a" || inside[1] != "did:sih:b" {
+		t.Errorf("expected two tourists in the park, got %v %v", inside, err)
+	}
+}
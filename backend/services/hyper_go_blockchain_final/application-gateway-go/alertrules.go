@@ -0,0 +1,771 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	alertRulesKey      = "sih:geofence:rules"
+	alertGroupsKey     = "sih:geofence:groups"
+	alertFiredPrefix   = "sih:geofence:fired:"
+	alertRuleReporter  = "geofence-rules"
+	maxAlertRuleName   = 200
+	maxAlertRuleDwell  = 24 * 60 // minutes
+	maxGroupMembers    = 50
+	minGroupSeparation = 50    // metres
+	maxGroupSeparation = 50000 // metres
+
+	ruleEntry           = "entry"
+	ruleExit            = "exit"
+	ruleDwell           = "dwell"
+	ruleAfterDarkEntry  = "after_dark_entry"
+	ruleGroupSeparation = "group_separation"
+
+	audienceResponders = "responders"
+	audienceTourist    = "tourist"
+	audienceGroup      = "group"
+
+	recordNone     = "none"
+	recordIncident = "incident"
+	recordSOS      = "sos"
+
+	// civilDusk is the sun's elevation in degrees below which it counts as dark
+	civilDusk = -6.0
+)
+
+var (
+	alertRuleConditions = []string{ruleEntry, ruleExit, ruleDwell, ruleAfterDarkEntry, ruleGroupSeparation}
+	alertRuleAudiences  = []string{audienceResponders, audienceTourist, audienceGroup}
+	alertRuleRecords    = []string{recordNone, recordIncident, recordSOS}
+)
+
+// AlertRuleRequest defines when a zone alerts and who hears about it. DwellMinutes is only used by
+// dwell rules and SeparationMeters only by group separation rules.
+type AlertRuleRequest struct {
+	ZoneID           string   `json:"zoneID" binding:"required"`
+	Name             string   `json:"name" binding:"required"`
+	Condition        string   `json:"condition" binding:"required"`
+	DwellMinutes     int      `json:"dwellMinutes"`
+	SeparationMeters float64  `json:"separationMeters"`
+	Severity         string   `json:"severity" binding:"required"`
+	Audience         []string `json:"audience"`
+	// Record is none, incident or sos; an incident carries the rule's severity
+	Record  string `json:"record"`
+	Enabled *bool  `json:"enabled"`
+	Actor   string `json:"actor" binding:"required"`
+}
+
+func (r AlertRuleRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.identifier("zoneID", r.ZoneID)
+	if len(r.Name) > maxAlertRuleName {
+		v.add("name", "must be at most %d characters", maxAlertRuleName)
+	}
+	v.oneOf("condition", r.Condition, alertRuleConditions)
+	switch {
+	case r.Condition == ruleDwell && (r.DwellMinutes <= 0 || r.DwellMinutes > maxAlertRuleDwell):
+		v.add("dwellMinutes", "must be between 1 and %d for dwell rules", maxAlertRuleDwell)
+	case r.Condition != ruleDwell && r.DwellMinutes != 0:
+		v.add("dwellMinutes", "is only used by dwell rules")
+	}
+	switch {
+	case r.Condition == ruleGroupSeparation && (r.SeparationMeters < minGroupSeparation || r.SeparationMeters > maxGroupSeparation):
+		v.add("separationMeters", "must be between %d and %d for group separation rules", minGroupSeparation, maxGroupSeparation)
+	case r.Condition != ruleGroupSeparation && r.SeparationMeters != 0:
+		v.add("separationMeters", "is only used by group separation rules")
+	}
+	v.oneOf("severity", r.Severity, incidentSeverities)
+	for i, audience := range r.Audience {
+		v.oneOf(fmt.Sprintf("audience[%d]", i), audience, alertRuleAudiences)
+	}
+	if r.Record != "" {
+		v.oneOf("record", r.Record, alertRuleRecords)
+	}
+	if len(r.Audience) == 0 && (r.Record == "" || r.Record == recordNone) {
+		v.add("audience", "must not be empty for a rule that records nothing")
+	}
+	return v.errors
+}
+
+// AlertRule is an operator-defined alert on one zone. Rules stay off the ledger; the incidents and
+// SOS alerts they raise are recorded on it.
+type AlertRule struct {
+	RuleID           string   `json:"rule_id"`
+	ZoneID           string   `json:"zone_id"`
+	Name             string   `json:"name"`
+	Condition        string   `json:"condition"`
+	DwellMinutes     int      `json:"dwell_minutes,omitempty"`
+	SeparationMeters float64  `json:"separation_meters,omitempty"`
+	Severity         string   `json:"severity"`
+	Audience         []string `json:"audience"`
+	Record           string   `json:"record"`
+	Enabled          bool     `json:"enabled"`
+	UpdatedBy        string   `json:"updated_by"`
+	UpdatedAt        string   `json:"updated_at"`
+}
+
+// TouristGroupRequest names tourists travelling together, whom group separation rules keep an eye on
+type TouristGroupRequest struct {
+	Name    string   `json:"name" binding:"required"`
+	Members []string `json:"members" binding:"required"`
+	Actor   string   `json:"actor" binding:"required"`
+}
+
+func (r TouristGroupRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	if len(r.Name) > maxAlertRuleName {
+		v.add("name", "must be at most %d characters", maxAlertRuleName)
+	}
+	if len(r.Members) < 2 || len(r.Members) > maxGroupMembers {
+		v.add("members", "must contain between 2 and %d tourists", maxGroupMembers)
+	}
+	seen := map[string]bool{}
+	for i, member := range r.Members {
+		v.digitalID(fmt.Sprintf("members[%d]", i), member)
+		if seen[member] {
+			v.add(fmt.Sprintf("members[%d]", i), "is listed twice")
+		}
+		seen[member] = true
+	}
+	return v.errors
+}
+
+// TouristGroup is a group as stored. A tourist may belong to several groups.
+type TouristGroup struct {
+	GroupID   string   `json:"group_id"`
+	Name      string   `json:"name"`
+	Members   []string `json:"members"`
+	UpdatedBy string   `json:"updated_by"`
+	UpdatedAt string   `json:"updated_at"`
+}
+
+// RuleAlert is a rule firing for a tourist. Each one fires once: entries and exits when they happen,
+// after-dark entries on entry, and dwell and group separation once per visit to the zone.
+type RuleAlert struct {
+	AlertID   string   `json:"alert_id"`
+	RuleID    string   `json:"rule_id"`
+	RuleName  string   `json:"rule_name"`
+	Condition string   `json:"condition"`
+	Severity  string   `json:"severity"`
+	DigitalID string   `json:"digital_id"`
+	ZoneID    string   `json:"zone_id"`
+	ZoneName  string   `json:"zone_name"`
+	Latitude  float64  `json:"latitude"`
+	Longitude float64  `json:"longitude"`
+	At        string   `json:"at"`
+	Detail    string   `json:"detail"`
+	Audience  []string `json:"audience"`
+	Record    string   `json:"record"`
+
+	// group is everyone in the tourist's groups, for the group audience
+	group []string
+}
+
+// alertRuleStore keeps rules and groups, and which alerts have fired. It uses Redis when the
+// document cache is configured, so rules apply and alerts fire once across gateway instances.
+type alertRuleStore interface {
+	rules(ctx context.Context) (map[string]AlertRule, error)
+	putRule(ctx context.Context, rule AlertRule) error
+	removeRule(ctx context.Context, ruleID string) (bool, error)
+	groups(ctx context.Context) (map[string]TouristGroup, error)
+	putGroup(ctx context.Context, group TouristGroup) error
+	removeGroup(ctx context.Context, groupID string) (bool, error)
+	// claim reports whether this is the first time an alert fired
+	claim(ctx context.Context, alertID string, ttl time.Duration) (bool, error)
+}
+
+// alertRuleEngine evaluates operator rules against the location stream, after the zone tracker
+type alertRuleEngine struct {
+	store alertRuleStore
+	// firedTTL is how long a fired alert is remembered, which must outlast a visit
+	firedTTL time.Duration
+	// groupFreshness is how old a group member's live location may be and still count
+	groupFreshness time.Duration
+}
+
+var alertRules *alertRuleEngine
+
+// initAlertRules runs from initGeofence, after initZoneTracker
+func initAlertRules() {
+	var store alertRuleStore = newMemoryAlertRuleStore()
+	backend := "memory"
+	if documentCache != nil {
+		store, backend = redisAlertRuleStore{documentCache}, "Redis"
+	}
+	alertRules = &alertRuleEngine{
+		store:          store,
+		firedTTL:       getEnvDuration("GEOFENCE_PRESENCE_TTL", 24*time.Hour),
+		groupFreshness: getEnvDuration("GEOFENCE_GROUP_FRESHNESS", 15*time.Minute),
+	}
+	log.Printf("🚧 Geofence alert rules kept in %s", backend)
+}
+
+// ruleAlertID is stable for an occurrence, so the incident or SOS it records is raised once
+func ruleAlertID(ruleID, digitalID, scope string, at time.Time) string {
+	sum := sha256.Sum256([]byte(ruleID + "|" + digitalID + "|" + scope))
+	return "RULE-" + at.UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(sum[:5])
+}
+
+// solarElevation is the sun's elevation in degrees at a position, from the low-precision solar
+// coordinates of the Astronomical Almanac, good to about a degree
+func solarElevation(lat, lng float64, at time.Time) float64 {
+	rad := math.Pi / 180
+	d := at.Sub(time.Date(2000, 1, 1, 12, 0, 0, 0, time.UTC)).Hours() / 24
+	g := (357.529 + 0.98560028*d) * rad
+	q := 280.459 + 0.98564736*d
+	ecliptic := (q + 1.915*math.Sin(g) + 0.020*math.Sin(2*g)) * rad
+	obliquity := (23.439 - 0.00000036*d) * rad
+	ascension := math.Atan2(math.Cos(obliquity)*math.Sin(ecliptic), math.Cos(ecliptic))
+	declination := math.Asin(math.Sin(obliquity) * math.Sin(ecliptic))
+	sidereal := math.Mod(280.46061837+360.98564736629*d+lng, 360) * rad
+	hour := sidereal - ascension
+	return math.Asin(math.Sin(lat*rad)*math.Sin(declination)+math.Cos(lat*rad)*math.Cos(declination)*math.Cos(hour)) / rad
+}
+
+// afterDark reports whether the sun is below civil dusk at a position
+func afterDark(lat, lng float64, at time.Time) bool {
+	return solarElevation(lat, lng, at) < civilDusk
+}
+
+// evaluate matches the tourist's zone events and current visits against the enabled rules. Entry,
+// exit and after-dark rules fire on the tracker's events; dwell and group separation rules hold
+// over a visit, so they are checked against the zones the tourist is in now.
+func (e *alertRuleEngine) evaluate(ctx context.Context, digitalID string, live LiveLocation, events []GeofenceEvent) ([]RuleAlert, error) {
+	all, err := e.store.rules(ctx)
+	if err != nil || len(all) == 0 {
+		return nil, err
+	}
+	byZone := map[string][]AlertRule{}
+	for _, id := range sortedKeys(all) {
+		if rule := all[id]; rule.Enabled {
+			byZone[rule.ZoneID] = append(byZone[rule.ZoneID], rule)
+		}
+	}
+
+	var groups []TouristGroup
+	groupsLoaded := false
+	touristGroups := func() ([]TouristGroup, error) {
+		if groupsLoaded {
+			return groups, nil
+		}
+		stored, err := e.store.groups(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range sortedKeys(stored) {
+			if slices.Contains(stored[id].Members, digitalID) {
+				groups = append(groups, stored[id])
+			}
+		}
+		groupsLoaded = true
+		return groups, nil
+	}
+
+	var alerts []RuleAlert
+	// occurred identifies the occurrence: the event for entries and exits, the visit otherwise
+	fire := func(rule AlertRule, zoneName, scope string, occurred time.Time, lat, lng float64, at time.Time, detail string) error {
+		alert := RuleAlert{
+			AlertID:   ruleAlertID(rule.RuleID, digitalID, scope, occurred),
+			RuleID:    rule.RuleID,
+			RuleName:  rule.Name,
+			Condition: rule.Condition,
+			Severity:  rule.Severity,
+			DigitalID: digitalID,
+			ZoneID:    rule.ZoneID,
+			ZoneName:  zoneName,
+			Latitude:  lat,
+			Longitude: lng,
+			At:        at.UTC().Format(time.RFC3339),
+			Detail:    detail,
+			Audience:  rule.Audience,
+			Record:    rule.Record,
+		}
+		first, err := e.store.claim(ctx, alert.AlertID, e.firedTTL)
+		if err != nil || !first {
+			return err
+		}
+		if slices.Contains(rule.Audience, audienceGroup) {
+			groups, err := touristGroups()
+			if err != nil {
+				return err
+			}
+			for _, group := range groups {
+				alert.group = append(alert.group, group.Members...)
+			}
+		}
+		alerts = append(alerts, alert)
+		return nil
+	}
+
+	for _, event := range events {
+		at, _ := time.Parse(time.RFC3339, event.At)
+		for _, rule := range byZone[event.ZoneID] {
+			var detail string
+			switch {
+			case rule.Condition == ruleEntry && event.Type == geofenceZoneEntry:
+				detail = "entered " + event.Name
+			case rule.Condition == ruleExit && event.Type == geofenceZoneExit:
+				detail = fmt.Sprintf("left %s after %s", event.Name, time.Duration(event.DwellSeconds)*time.Second)
+			case rule.Condition == ruleAfterDarkEntry && event.Type == geofenceZoneEntry && afterDark(event.Latitude, event.Longitude, at):
+				detail = "entered " + event.Name + " after dark"
+			default:
+				continue
+			}
+			if err := fire(rule, event.Name, event.Type, at, event.Latitude, event.Longitude, at, detail); err != nil {
+				return alerts, err
+			}
+		}
+	}
+
+	presence, err := zoneTracker.store.load(ctx, digitalID)
+	if err != nil {
+		return alerts, err
+	}
+	at, err := time.Parse(time.RFC3339, presence.LastAt)
+	if err != nil {
+		return alerts, nil
+	}
+	for _, zoneID := range sortedKeys(presence.Zones) {
+		visit := presence.Zones[zoneID]
+		entered, _ := time.Parse(time.RFC3339, visit.EnteredAt)
+		for _, rule := range byZone[zoneID] {
+			switch rule.Condition {
+			case ruleDwell:
+				dwell := at.Sub(entered)
+				if dwell < time.Duration(rule.DwellMinutes)*time.Minute {
+					continue
+				}
+				detail := fmt.Sprintf("has been in %s for %s", visit.Name, dwell)
+				if err := fire(rule, visit.Name, rule.Condition, entered, live.Latitude, live.Longitude, at, detail); err != nil {
+					return alerts, err
+				}
+			case ruleGroupSeparation:
+				groups, err := touristGroups()
+				if err != nil {
+					return alerts, err
+				}
+				for _, group := range groups {
+					metres, ok, err := e.separation(ctx, digitalID, group, live, at)
+					if err != nil {
+						return alerts, err
+					}
+					if !ok || metres <= rule.SeparationMeters {
+						continue
+					}
+					detail := fmt.Sprintf("is %.0f m from the nearest member of %s in %s", metres, group.Name, visit.Name)
+					if err := fire(rule, visit.Name, rule.Condition+"|"+group.GroupID, entered, live.Latitude, live.Longitude, at, detail); err != nil {
+						return alerts, err
+					}
+				}
+			}
+		}
+	}
+	return alerts, nil
+}
+
+// separation is how far the tourist is from the nearest other member of the group whose live
+// location is no older than GEOFENCE_GROUP_FRESHNESS. It is not known when no other member is.
+func (e *alertRuleEngine) separation(ctx context.Context, digitalID string, group TouristGroup, live LiveLocation, at time.Time) (float64, bool, error) {
+	if locations == nil {
+		return 0, false, nil
+	}
+	nearest, known := math.Inf(1), false
+	for _, member := range group.Members {
+		if member == digitalID {
+			continue
+		}
+		other, err := locations.store.latest(ctx, member)
+		if err != nil {
+			return 0, false, err
+		}
+		if other == nil {
+			continue
+		}
+		if recorded, _ := time.Parse(time.RFC3339, other.RecordedAt); at.Sub(recorded).Abs() > e.groupFreshness {
+			continue
+		}
+		nearest, known = math.Min(nearest, haversineKm(live.Latitude, live.Longitude, other.Latitude, other.Longitude)*1000), true
+	}
+	return nearest, known, nil
+}
+
+// raise records each alert as its rule asks and pushes it to the rule's audiences. Incidents and
+// SOS alerts are raised as the gateway, not as the caller whose upload fired the rule, and reach
+// dispatch and escalation like any other.
+func (e *alertRuleEngine) raise(ctx context.Context, org string, alerts []RuleAlert) {
+	for _, a := range alerts {
+		log.Printf("🚧 Rule %s fired for %s: %s", a.RuleID, a.DigitalID, a.Detail)
+		lat, lng := a.Latitude, a.Longitude
+		var err error
+		switch a.Record {
+		case recordIncident:
+			summary, _ := json.Marshal(a)
+			sum := sha256.Sum256(summary)
+			_, err = ledger.CreateIncident(ctx, CreateIncidentRequest{
+				IncidentID:          a.AlertID,
+				IncidentSummaryHash: hex.EncodeToString(sum[:]),
+				Reporter:            alertRuleReporter,
+				Severity:            a.Severity,
+				Category:            "geofence",
+				DigitalID:           a.DigitalID,
+				Latitude:            &lat,
+				Longitude:           &lng,
+			})
+		case recordSOS:
+			_, err = ledger.RaiseSOS(ctx, SOSRequest{
+				AlertID:   a.AlertID,
+				DigitalID: a.DigitalID,
+				Latitude:  &lat,
+				Longitude: &lng,
+				Message:   a.RuleName + ": tourist " + a.Detail,
+				Source:    "geofence",
+			})
+		}
+		if err != nil && translateFabricError(err).Code != errCodeAlreadyExists {
+			log.Printf("🚧 Failed to record %s for rule alert %s: %v", a.Record, a.AlertID, err)
+		}
+
+		if pusher == nil {
+			continue
+		}
+		for _, audience := range a.Audience {
+			alert := pushAlert{
+				key:   "rule:" + a.AlertID + ":" + audience,
+				zone:  a.ZoneID,
+				org:   org,
+				title: a.RuleName,
+				body:  "Tourist " + a.DigitalID + " " + a.Detail,
+				data:  map[string]string{"type": "geofence_rule", "rule_id": a.RuleID, "alert_id": a.AlertID, "condition": a.Condition, "severity": a.Severity, "digital_id": a.DigitalID, "zone_id": a.ZoneID, "at": a.At},
+			}
+			switch audience {
+			case audienceTourist:
+				alert.tourists = map[string]bool{a.DigitalID: true}
+			case audienceGroup:
+				if len(a.group) == 0 {
+					continue
+				}
+				alert.tourists = map[string]bool{}
+				for _, member := range a.group {
+					alert.tourists[member] = true
+				}
+			}
+			pusher.deliver(ctx, alert)
+		}
+	}
+}
+
+type redisAlertRuleStore struct {
+	redis *redisClient
+}
+
+func (s redisAlertRuleStore) rules(ctx context.Context) (map[string]AlertRule, error) {
+	return redisHashJSON[AlertRule](ctx, s.redis, alertRulesKey)
+}
+
+func (s redisAlertRuleStore) putRule(ctx context.Context, rule AlertRule) error {
+	data, err := json.Marshal(rule)
+	if err != nil {
+		return err
+	}
+	_, err = s.redis.Do(ctx, "HSET", alertRulesKey, rule.RuleID, string(data))
+	return err
+}
+
+func (s redisAlertRuleStore) removeRule(ctx context.Context, ruleID string) (bool, error) {
+	reply, err := s.redis.Do(ctx, "HDEL", alertRulesKey, ruleID)
+	n, _ := reply.(int64)
+	return n == 1, err
+}
+
+func (s redisAlertRuleStore) groups(ctx context.Context) (map[string]TouristGroup, error) {
+	return redisHashJSON[TouristGroup](ctx, s.redis, alertGroupsKey)
+}
+
+func (s redisAlertRuleStore) putGroup(ctx context.Context, group TouristGroup) error {
+	data, err := json.Marshal(group)
+	if err != nil {
+		return err
+	}
+	_, err = s.redis.Do(ctx, "HSET", alertGroupsKey, group.GroupID, string(data))
+	return err
+}
+
+func (s redisAlertRuleStore) removeGroup(ctx context.Context, groupID string) (bool, error) {
+	reply, err := s.redis.Do(ctx, "HDEL", alertGroupsKey, groupID)
+	n, _ := reply.(int64)
+	return n == 1, err
+}
+
+func (s redisAlertRuleStore) claim(ctx context.Context, alertID string, ttl time.Duration) (bool, error) {
+	_, err := s.redis.Do(ctx, "SET", alertFiredPrefix+alertID, "1", "NX", "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	if errors.Is(err, errRedisNil) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// memoryAlertRuleStore serves a single gateway instance
+type memoryAlertRuleStore struct {
+	mu          sync.Mutex
+	ruleSet     map[string]AlertRule
+	groupSet    map[string]TouristGroup
+	firedExpiry map[string]time.Time
+}
+
+func newMemoryAlertRuleStore() *memoryAlertRuleStore {
+	return &memoryAlertRuleStore{ruleSet: map[string]AlertRule{}, groupSet: map[string]TouristGroup{}, firedExpiry: map[string]time.Time{}}
+}
+
+func (s *memoryAlertRuleStore) rules(_ context.Context) (map[string]AlertRule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rules := make(map[string]AlertRule, len(s.ruleSet))
+	for id, rule := range s.ruleSet {
+		rules[id] = rule
+	}
+	return rules, nil
+}
+
+func (s *memoryAlertRuleStore) putRule(_ context.Context, rule AlertRule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ruleSet[rule.RuleID] = rule
+	return nil
+}
+
+func (s *memoryAlertRuleStore) removeRule(_ context.Context, ruleID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.ruleSet[ruleID]
+	delete(s.ruleSet, ruleID)
+	return ok, nil
+}
+
+func (s *memoryAlertRuleStore) groups(_ context.Context) (map[string]TouristGroup, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	groups := make(map[string]TouristGroup, len(s.groupSet))
+	for id, group := range s.groupSet {
+		groups[id] = group
+	}
+	return groups, nil
+}
+
+func (s *memoryAlertRuleStore) putGroup(_ context.Context, group TouristGroup) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.groupSet[group.GroupID] = group
+	return nil
+}
+
+func (s *memoryAlertRuleStore) removeGroup(_ context.Context, groupID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.groupSet[groupID]
+	delete(s.groupSet, groupID)
+	return ok, nil
+}
+
+func (s *memoryAlertRuleStore) claim(_ context.Context, alertID string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if expiry, ok := s.firedExpiry[alertID]; ok && now.Before(expiry) {
+		return false, nil
+	}
+	s.firedExpiry[alertID] = now.Add(ttl)
+	return true, nil
+}
+
+func listAlertRules(c *gin.Context) {
+	zoneID := c.Query("zoneId")
+	if zoneID != "" {
+		var v fieldValidator
+		if v.identifier("zoneId", zoneID); len(v.errors) > 0 {
+			respondValidationErrors(c, v.errors)
+			return
+		}
+	}
+	all, err := alertRules.store.rules(c.Request.Context())
+	if err != nil {
+		respondServiceError(c, "Failed to list alert rules", err)
+		return
+	}
+	rules := []AlertRule{}
+	for _, id := range sortedKeys(all) {
+		if zoneID == "" || all[id].ZoneID == zoneID {
+			rules = append(rules, all[id])
+		}
+	}
+	respondData(c, http.StatusOK, rules)
+}
+
+func getAlertRule(c *gin.Context) {
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	rules, err := alertRules.store.rules(c.Request.Context())
+	if err != nil {
+		respondServiceError(c, "Failed to read alert rule", err)
+		return
+	}
+	rule, ok := rules[id]
+	if !ok {
+		respondError(c, http.StatusNotFound, errCodeNotFound, "No alert rule with this ID")
+		return
+	}
+	respondData(c, http.StatusOK, rule)
+}
+
+// putAlertRule creates or replaces a rule. The zone must exist on the ledger; rules on a zone that
+// is later deleted stay stored but never fire.
+func putAlertRule(c *gin.Context) {
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	var req AlertRuleRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+	ctx := c.Request.Context()
+	if _, err := ledger.GetZone(ctx, req.ZoneID); err != nil {
+		respondServiceError(c, "Failed to read zone", err)
+		return
+	}
+	rule := AlertRule{
+		RuleID:           id,
+		ZoneID:           req.ZoneID,
+		Name:             req.Name,
+		Condition:        req.Condition,
+		DwellMinutes:     req.DwellMinutes,
+		SeparationMeters: req.SeparationMeters,
+		Severity:         req.Severity,
+		Audience:         slices.Compact(slices.Sorted(slices.Values(req.Audience))),
+		Record:           req.Record,
+		Enabled:          req.Enabled == nil || *req.Enabled,
+		UpdatedBy:        req.Actor,
+		UpdatedAt:        time.Now().UTC().Format(time.RFC3339),
+	}
+	if rule.Record == "" {
+		rule.Record = recordNone
+	}
+	if rule.Audience == nil {
+		rule.Audience = []string{}
+	}
+	setAuditTarget(c, id)
+	if err := alertRules.store.putRule(ctx, rule); err != nil {
+		respondServiceError(c, "Failed to save alert rule", err)
+		return
+	}
+	respondData(c, http.StatusOK, rule)
+}
+
+func deleteAlertRule(c *gin.Context) {
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	setAuditTarget(c, id)
+	removed, err := alertRules.store.removeRule(c.Request.Context(), id)
+	if err != nil {
+		respondServiceError(c, "Failed to delete alert rule", err)
+		return
+	}
+	if !removed {
+		respondError(c, http.StatusNotFound, errCodeNotFound, "No alert rule with this ID")
+		return
+	}
+	respondData(c, http.StatusOK, gin.H{"message": "Alert rule deleted successfully", "ruleID": id})
+}
+
+func listTouristGroups(c *gin.Context) {
+	all, err := alertRules.store.groups(c.Request.Context())
+	if err != nil {
+		respondServiceError(c, "Failed to list tourist groups", err)
+		return
+	}
+	groups := []TouristGroup{}
+	for _, id := range sortedKeys(all) {
+		groups = append(groups, all[id])
+	}
+	respondData(c, http.StatusOK, groups)
+}
+
+func getTouristGroup(c *gin.Context) {
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	groups, err := alertRules.store.groups(c.Request.Context())
+	if err != nil {
+		respondServiceError(c, "Failed to read tourist group", err)
+		return
+	}
+	group, ok := groups[id]
+	if !ok {
+		respondError(c, http.StatusNotFound, errCodeNotFound, "No tourist group with this ID")
+		return
+	}
+	respondData(c, http.StatusOK, group)
+}
+
+func putTouristGroup(c *gin.Context) {
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	var req TouristGroupRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+	members := slices.Clone(req.Members)
+	sort.Strings(members)
+	group := TouristGroup{GroupID: id, Name: req.Name, Members: members, UpdatedBy: req.Actor, UpdatedAt: time.Now().UTC().Format(time.RFC3339)}
+	setAuditTarget(c, id)
+	if err := alertRules.store.putGroup(c.Request.Context(), group); err != nil {
+		respondServiceError(c, "Failed to save tourist group", err)
+		return
+	}
+	respondData(c, http.StatusOK, group)
+}
+
+func deleteTouristGroup(c *gin.Context) {
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	setAuditTarget(c, id)
+	removed, err := alertRules.store.removeGroup(c.Request.Context(), id)
+	if err != nil {
+		respondServiceError(c, "Failed to delete tourist group", err)
+		return
+	}
+	if !removed {
+		respondError(c, http.StatusNotFound, errCodeNotFound, "No tourist group with this ID")
+		return
+	}
+	respondData(c, http.StatusOK, gin.H{"message": "Tourist group deleted successfully", "groupID": id})
+}
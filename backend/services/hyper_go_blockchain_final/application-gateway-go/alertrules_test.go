@@ -0,0 +1,107 @@
+package gateway
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestAlertRules(t *testing.T) {
+	geofence = newGeofenceIndex()
+	previousTracker, previousLocations := zoneTracker, locations
+	defer func() { geofence, zoneTracker, locations = nil, previousTracker, previousLocations }()
+	z, err := newIndexedZone(ZoneDocument{ZoneID: "falls", Name: "Elephant Falls", Geometry: `{"type":"Circle","coordinates":[91.82,25.54],"radius":2000}`, RiskLevel: "high"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	geofence.put(z)
+	zoneTracker = &geofenceTracker{store: newMemoryPresenceStore(time.Hour), dwellLimits: map[string]time.Duration{}}
+	locations = &locationService{store: newMemoryLocationStore(time.Hour)}
+	engine := &alertRuleEngine{store: newMemoryAlertRuleStore(), firedTTL: time.Hour, groupFreshness: 15 * time.Minute}
+	ctx := context.Background()
+
+	for _, rule := range []AlertRule{
+		{RuleID: "falls-entry", ZoneID: "falls", Condition: ruleEntry, Audience: []string{audienceResponders}, Enabled: true},
+		{RuleID: "falls-exit", ZoneID: "falls", Condition: ruleExit, Audience: []string{audienceResponders}, Enabled: true},
+		{RuleID: "falls-dark", ZoneID: "falls", Condition: ruleAfterDarkEntry, Audience: []string{audienceTourist}, Record: recordIncident, Enabled: true},
+		{RuleID: "falls-dwell", ZoneID: "falls", Condition: ruleDwell, DwellMinutes: 10, Record: recordSOS, Enabled: true},
+		{RuleID: "falls-apart", ZoneID: "falls", Condition: ruleGroupSeparation, SeparationMeters: 500, Audience: []string{audienceGroup}, Enabled: true},
+		{RuleID: "falls-off", ZoneID: "falls", Condition: ruleEntry, Audience: []string{audienceResponders}},
+	} {
+		engine.store.putRule(ctx, rule)
+	}
+	engine.store.putGroup(ctx, TouristGroup{GroupID: "trek-1", Name: "Trek party", Members: []string{"did:sih:t1", "did:sih:t2"}})
+
+	// 14:00 UTC is after dusk in Shillong in January
+	evening := time.Date(2025, 1, 15, 14, 0, 0, 0, time.UTC)
+	locations.store.update(ctx, LiveLocation{DigitalID: "did:sih:t2", Latitude: 25.54, Longitude: 91.82, RecordedAt: evening.Format(time.RFC3339), ReceivedAt: time.Now().UTC().Format(time.RFC3339)})
+	upload := func(digitalID string, lat, lng float64, at time.Time) []string {
+		t.Helper()
+		pings := []LocationPing{{Latitude: &lat, Longitude: &lng, RecordedAt: at.Format(time.RFC3339)}}
+		events, err := zoneTracker.track(ctx, digitalID, pings)
+		if err != nil {
+			t.Fatal(err)
+		}
+		alerts, err := engine.evaluate(ctx, digitalID, LiveLocation{DigitalID: digitalID, Latitude: lat, Longitude: lng, RecordedAt: at.Format(time.RFC3339)}, events)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var fired []string
+		for _, a := range alerts {
+			fired = append(fired, a.RuleID)
+			if a.RuleID == "falls-apart" && !slices.Contains(a.group, "did:sih:t2") {
+				t.Errorf("expected the group audience resolved, got %+v", a)
+			}
+		}
+		return fired
+	}
+	expect := func(got []string, want ...string) {
+		t.Helper()
+		if !slices.Equal(got, want) {
+			t.Errorf("fired %v, expected %v", got, want)
+		}
+	}
+
+	expect(upload("did:sih:t1", 25.54, 91.82, evening), "falls-dark", "falls-entry")
+	// Fifteen minutes in, 1.1 km from the rest of the party: dwell and separation fire once per visit
+	expect(upload("did:sih:t1", 25.55, 91.82, evening.Add(15*time.Minute)), "falls-apart", "falls-dwell")
+	expect(upload("did:sih:t1", 25.551, 91.82, evening.Add(20*time.Minute)))
+	expect(upload("did:sih:t1", 25.70, 91.82, evening.Add(30*time.Minute)), "falls-exit")
+
+	// By day an entry is only an entry
+	morning := time.Date(2025, 1, 15, 6, 0, 0, 0, time.UTC)
+	expect(upload("did:sih:t3", 25.54, 91.82, morning), "falls-entry")
+	if elevation := solarElevation(25.54, 91.82, morning); elevation < 30 {
+		t.Errorf("expected the sun high over Shillong at 11:30 IST, got %.1f°", elevation)
+	}
+
+	if first := ruleAlertID("falls-dwell", "did:sih:t1", ruleDwell, evening); first != ruleAlertID("falls-dwell", "did:sih:t1", ruleDwell, evening) ||
+		first == ruleAlertID("falls-dwell", "did:sih:t3", ruleDwell, evening) {
+		t.Error("expected alert IDs stable per occurrence and distinct per tourist")
+	}
+}
+
+func TestAlertRuleValidation(t *testing.T) {
+	valid := AlertRuleRequest{ZoneID: "falls", Name: "Dwell", Condition: ruleDwell, DwellMinutes: 30, Severity: "high", Record: recordIncident, Actor: "ops"}
+	if errs := valid.Validate(); len(errs) > 0 {
+		t.Fatalf("unexpected errors %v", errs)
+	}
+	for name, req := range map[string]AlertRuleRequest{
+		"dwell without minutes": {ZoneID: "falls", Condition: ruleDwell, Severity: "high", Record: recordSOS},
+		"minutes on entry":      {ZoneID: "falls", Condition: ruleEntry, DwellMinutes: 5, Severity: "high", Record: recordSOS},
+		"separation too small":  {ZoneID: "falls", Condition: ruleGroupSeparation, SeparationMeters: 10, Severity: "high", Record: recordSOS},
+		"unknown audience":      {ZoneID: "falls", Condition: ruleEntry, Severity: "high", Audience: []string{"everyone"}},
+		"nobody hears about it": {ZoneID: "falls", Condition: ruleEntry, Severity: "high"},
+		"unknown severity":      {ZoneID: "falls", Condition: ruleEntry, Severity: "urgent", Record: recordSOS},
+		"unknown record":        {ZoneID: "falls", Condition: ruleEntry, Severity: "high", Record: "fir"},
+		"unknown condition":     {ZoneID: "falls", Condition: "loiter", Severity: "high", Record: recordSOS},
+	} {
+		if errs := req.Validate(); len(errs) == 0 {
+			t.Errorf("%s: expected rejected", name)
+		}
+	}
+	if errs := (TouristGroupRequest{Name: "Pair", Members: []string{"did:sih:t1", "did:sih:t1"}}).Validate(); len(errs) == 0 {
+		t.Error("expected a repeated member rejected")
+	}
+}
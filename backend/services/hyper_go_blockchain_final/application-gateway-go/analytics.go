@@ -0,0 +1,451 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	analyticsVisitsPrefix  = "sih:analytics:visits:"
+	analyticsVisitorPrefix = "sih:analytics:visitor:"
+	// maxAnalyticsDays bounds one export, which reads every incident in its range
+	maxAnalyticsDays = 92
+
+	errCodeAnalyticsDisabled = "ANALYTICS_DISABLED"
+)
+
+// countAnalyticsVisit counts a visitor in a zone once a day, and in at most ARGV[2] zones a day,
+// which bounds how much one person can change the day's counts
+const countAnalyticsVisit = `if redis.call("SISMEMBER", KEYS[1], ARGV[1]) == 1 then return 0 end
+if redis.call("SCARD", KEYS[1]) >= tonumber(ARGV[2]) then return 0 end
+redis.call("SADD", KEYS[1], ARGV[1])
+redis.call("PEXPIRE", KEYS[1], ARGV[3])
+redis.call("HINCRBY", KEYS[2], ARGV[1], 1)
+redis.call("PEXPIRE", KEYS[2], ARGV[3])
+return 1`
+
+// AnalyticsRequest selects whole days in ANALYTICS_TIMEZONE, all of which must have ended
+type AnalyticsRequest struct {
+	From string `form:"from" binding:"required"`
+	To   string `form:"to" binding:"required"`
+}
+
+func (r AnalyticsRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	from, fromErr := time.Parse(time.DateOnly, r.From)
+	if fromErr != nil {
+		v.add("from", "must be a date such as 2026-10-01")
+	}
+	to, toErr := time.Parse(time.DateOnly, r.To)
+	if toErr != nil {
+		v.add("to", "must be a date such as 2026-10-31")
+	}
+	if fromErr == nil && toErr == nil {
+		if to.Before(from) {
+			v.add("to", "must not be before from")
+		} else if days := int(to.Sub(from).Hours()/24) + 1; days > maxAnalyticsDays {
+			v.add("to", "must be at most %d days after from", maxAnalyticsDays-1)
+		}
+	}
+	return v.errors
+}
+
+// AnalyticsExportRequest selects the days to export as CSV or XLSX
+type AnalyticsExportRequest struct {
+	From   string `form:"from" binding:"required"`
+	To     string `form:"to" binding:"required"`
+	Format string `form:"format"`
+}
+
+func (r AnalyticsExportRequest) Validate() ValidationErrors {
+	errs := AnalyticsRequest{From: r.From, To: r.To}.Validate()
+	return append(errs, exportFormatErrors(r.Format)...)
+}
+
+// AnalyticsPrivacy states how an export was protected. Each day's counts are differentially
+// private with EpsilonPerDay, split between visitors and incidents; a person counts in at most
+// MaxZonesPerDay zones a day. Counts below MinCount are withheld.
+type AnalyticsPrivacy struct {
+	Mechanism        string  `json:"mechanism"`
+	EpsilonPerDay    float64 `json:"epsilon_per_day"`
+	VisitorsEpsilon  float64 `json:"visitors_epsilon"`
+	IncidentsEpsilon float64 `json:"incidents_epsilon"`
+	MaxZonesPerDay   int     `json:"max_zones_per_day"`
+	MinCount         int     `json:"min_count"`
+}
+
+// AnalyticsRow is one zone on one day. Visitors is the noisy count of distinct tourists who
+// entered the zone; a count withheld for falling below the threshold is null, and withholding the
+// visitors withholds the whole row.
+type AnalyticsRow struct {
+	Date             string   `json:"date"`
+	ZoneID           string   `json:"zone_id"`
+	ZoneName         string   `json:"zone_name"`
+	Visitors         *int     `json:"visitors"`
+	Incidents        *int     `json:"incidents"`
+	IncidentsPer1000 *float64 `json:"incidents_per_1000_visitors"`
+}
+
+// AnalyticsExport is aggregate tourism data fit for publication as open data
+type AnalyticsExport struct {
+	From        string           `json:"from"`
+	To          string           `json:"to"`
+	Timezone    string           `json:"timezone"`
+	GeneratedAt string           `json:"generated_at"`
+	Privacy     AnalyticsPrivacy `json:"privacy"`
+	Rows        []AnalyticsRow   `json:"rows"`
+}
+
+// visitStore keeps the true visitor counts by zone and day, in Redis when the document cache is
+// configured and in memory otherwise. They never leave the gateway without noise.
+type visitStore interface {
+	// count counts a visitor in a zone on a day, unless they are already counted there or in limit
+	// zones that day
+	count(ctx context.Context, day, zoneID, visitor string, limit int) error
+	visits(ctx context.Context, day string) (map[string]int, error)
+}
+
+// analyticsExporter counts zone visits as tourists move and exports them, with incident counts,
+// under differential privacy
+type analyticsExporter struct {
+	store     visitStore
+	incidents func(ctx context.Context, from, to time.Time) ([]IncidentDocument, error)
+	location  *time.Location
+	secret    []byte
+	epsilon   float64
+	maxZones  int
+	minCount  int
+}
+
+var analytics *analyticsExporter
+
+// initAnalytics enables the analytics export when ANALYTICS_SECRET is set. The secret keys the
+// visitor pseudonyms and the noise, so it must stay the same across restarts and instances. Runs
+// after initDocumentCache and initGeofence.
+func initAnalytics() {
+	secret := getEnv("ANALYTICS_SECRET", "")
+	if secret == "" {
+		log.Println("📈 ANALYTICS_SECRET not set, the analytics export is disabled")
+		return
+	}
+	if len(secret) < 32 {
+		panic(fmt.Errorf("ANALYTICS_SECRET must be at least 32 bytes"))
+	}
+	timezone := getEnv("ANALYTICS_TIMEZONE", defaultZoneTimezone)
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		panic(fmt.Errorf("ANALYTICS_TIMEZONE %q is not a known timezone", timezone))
+	}
+	a := &analyticsExporter{
+		incidents: ledgerReportSource{}.incidents,
+		location:  location,
+		secret:    []byte(secret),
+		epsilon:   getEnvFloat("ANALYTICS_EPSILON", 1),
+		maxZones:  getEnvInt("ANALYTICS_MAX_ZONES_PER_DAY", 5),
+		minCount:  getEnvInt("ANALYTICS_MIN_COUNT", 10),
+	}
+	if a.epsilon <= 0 || a.maxZones < 1 || a.minCount < 1 {
+		panic(errors.New("ANALYTICS_EPSILON must be positive, and ANALYTICS_MAX_ZONES_PER_DAY and ANALYTICS_MIN_COUNT at least 1"))
+	}
+	retention := getEnvDuration("ANALYTICS_RETENTION", 400*24*time.Hour)
+	if retention < 24*time.Hour {
+		panic(errors.New("ANALYTICS_RETENTION must be at least 24h"))
+	}
+
+	a.store = newMemoryVisitStore(retention)
+	backend := "memory"
+	if documentCache != nil {
+		a.store, backend = redisVisitStore{documentCache, retention}, "Redis"
+	}
+	analytics = a
+	log.Printf("📈 Counting zone visits for analytics in %s, kept in %s for %s, exported with ε=%g a day", timezone, backend, retention, a.epsilon)
+}
+
+// visitor is a tourist's pseudonym for one day. It changes every day, so stored counts cannot link
+// a tourist's visits across days.
+func (a *analyticsExporter) visitor(day, digitalID string) string {
+	mac := hmac.New(sha256.New, a.secret)
+	mac.Write([]byte("visitor\n" + day + "\n" + digitalID))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// recordVisits counts the zones tourists entered. A failure loses a visit from the statistics, so
+// it is logged rather than failing the pings.
+func (a *analyticsExporter) recordVisits(ctx context.Context, events []GeofenceEvent) {
+	if a == nil {
+		return
+	}
+	for _, event := range events {
+		if event.Type != geofenceZoneEntry {
+			continue
+		}
+		at, err := time.Parse(time.RFC3339, event.At)
+		if err != nil {
+			continue
+		}
+		day := at.In(a.location).Format(time.DateOnly)
+		if err := a.store.count(ctx, day, event.ZoneID, a.visitor(day, event.DigitalID), a.maxZones); err != nil {
+			logWithContext(ctx, "📈 Failed to count a visit to zone %s: %v", event.ZoneID, err)
+		}
+	}
+}
+
+// noise draws discrete Laplace noise for a cell, with the scale the bound on contributions and
+// epsilon give. It is seeded by the cell, so exporting the same day again gives the same counts
+// and repeated exports cannot be averaged to remove the noise.
+func (a *analyticsExporter) noise(metric, day, zoneID string, epsilon float64) int {
+	mac := hmac.New(sha256.New, a.secret)
+	mac.Write([]byte("noise\n" + metric + "\n" + day + "\n" + zoneID))
+	var seed [32]byte
+	copy(seed[:], mac.Sum(nil))
+	rng := rand.New(rand.NewChaCha8(seed))
+	alpha := math.Exp(-epsilon / float64(a.maxZones))
+	return geometric(rng, alpha) - geometric(rng, alpha)
+}
+
+// geometric draws the number of failures before a success, with failures of probability alpha. The
+// difference of two such draws is discrete Laplace.
+func geometric(rng *rand.Rand, alpha float64) int {
+	return int(math.Floor(math.Log(1-rng.Float64()) / math.Log(alpha)))
+}
+
+// release adds noise to a count and withholds it below the threshold. Noise is drawn for zero
+// counts too, so that a published row does not reveal that someone was there.
+func (a *analyticsExporter) release(count int, metric, day, zoneID string, epsilon float64) *int {
+	noisy := max(count+a.noise(metric, day, zoneID, epsilon), 0)
+	if noisy < a.minCount {
+		return nil
+	}
+	return &noisy
+}
+
+// incidentCounts counts, by day and zone, the people incidents were about. Each person counts once
+// in a zone and in at most maxZones zones a day, as visitors do.
+func (a *analyticsExporter) incidentCounts(incidents []IncidentDocument) map[string]map[string]int {
+	counts := map[string]map[string]int{}
+	zonesOf := map[string][]string{}
+	for _, incident := range incidents {
+		createdAt, err := time.Parse(time.RFC3339, incident.CreatedAt)
+		if err != nil {
+			continue
+		}
+		cell, ok := geohashBounds(incident.Geohash)
+		if !ok {
+			continue
+		}
+		day := createdAt.In(a.location).Format(time.DateOnly)
+		person := day + "\n" + cmpOr(incident.SubjectID, cmpOr(incident.Reporter, incident.IncidentID))
+		for _, match := range geofence.evaluate((cell.minLat+cell.maxLat)/2, (cell.minLng+cell.maxLng)/2, createdAt).Zones {
+			if slices.Contains(zonesOf[person], match.ZoneID) || len(zonesOf[person]) >= a.maxZones {
+				continue
+			}
+			zonesOf[person] = append(zonesOf[person], match.ZoneID)
+			if counts[day] == nil {
+				counts[day] = map[string]int{}
+			}
+			counts[day][match.ZoneID]++
+		}
+	}
+	return counts
+}
+
+// export builds the rows of every zone on every day in the request. Days that have not ended are
+// refused, as their counts are still changing.
+func (a *analyticsExporter) export(ctx context.Context, req AnalyticsRequest, now time.Time) (AnalyticsExport, error) {
+	if errs := req.Validate(); len(errs) > 0 {
+		return AnalyticsExport{}, errs
+	}
+	from, _ := time.ParseInLocation(time.DateOnly, req.From, a.location)
+	to, _ := time.ParseInLocation(time.DateOnly, req.To, a.location)
+	end := to.AddDate(0, 0, 1)
+	if end.After(now) {
+		return AnalyticsExport{}, ValidationErrors{{Field: "to", Message: "must be a day that has ended in " + a.location.String()}}
+	}
+
+	incidents, err := a.incidents(ctx, from, end)
+	if err != nil {
+		return AnalyticsExport{}, err
+	}
+	incidentCounts := a.incidentCounts(incidents)
+	share := a.epsilon / 2
+	export := AnalyticsExport{
+		From:        req.From,
+		To:          req.To,
+		Timezone:    a.location.String(),
+		GeneratedAt: now.UTC().Format(time.RFC3339),
+		Privacy: AnalyticsPrivacy{
+			Mechanism:        "discrete_laplace",
+			EpsilonPerDay:    a.epsilon,
+			VisitorsEpsilon:  share,
+			IncidentsEpsilon: share,
+			MaxZonesPerDay:   a.maxZones,
+			MinCount:         a.minCount,
+		},
+		Rows: []AnalyticsRow{},
+	}
+	zones := geofence.snapshot()
+	for date := from; date.Before(end); date = date.AddDate(0, 0, 1) {
+		day := date.Format(time.DateOnly)
+		visits, err := a.store.visits(ctx, day)
+		if err != nil {
+			return AnalyticsExport{}, err
+		}
+		for _, z := range zones {
+			zone := z.zone
+			row := AnalyticsRow{Date: day, ZoneID: zone.ZoneID, ZoneName: zone.Name}
+			row.Visitors = a.release(visits[zone.ZoneID], "visitors", day, zone.ZoneID, share)
+			if row.Visitors != nil {
+				row.Incidents = a.release(incidentCounts[day][zone.ZoneID], "incidents", day, zone.ZoneID, share)
+			}
+			if row.Incidents != nil {
+				rate := math.Round(float64(*row.Incidents)/float64(*row.Visitors)*1000*100) / 100
+				row.IncidentsPer1000 = &rate
+			}
+			export.Rows = append(export.Rows, row)
+		}
+	}
+	return export, nil
+}
+
+type redisVisitStore struct {
+	redis *redisClient
+	ttl   time.Duration
+}
+
+func (s redisVisitStore) count(ctx context.Context, day, zoneID, visitor string, limit int) error {
+	_, err := s.redis.Do(ctx, "EVAL", countAnalyticsVisit, "2", analyticsVisitorPrefix+day+":"+visitor, analyticsVisitsPrefix+day,
+		zoneID, strconv.Itoa(limit), strconv.FormatInt(s.ttl.Milliseconds(), 10))
+	return err
+}
+
+func (s redisVisitStore) visits(ctx context.Context, day string) (map[string]int, error) {
+	reply, err := s.redis.Do(ctx, "HGETALL", analyticsVisitsPrefix+day)
+	if err != nil {
+		return nil, err
+	}
+	fields, _ := reply.([]interface{})
+	visits := make(map[string]int, len(fields)/2)
+	for i := 0; i+1 < len(fields); i += 2 {
+		zoneID, _ := fields[i].([]byte)
+		count, _ := fields[i+1].([]byte)
+		visits[string(zoneID)], _ = strconv.Atoi(string(count))
+	}
+	return visits, nil
+}
+
+// memoryVisitStore serves a single gateway instance, dropping days older than the retention
+type memoryVisitStore struct {
+	mu        sync.Mutex
+	retention time.Duration
+	counted   map[string]map[string][]string
+	counts    map[string]map[string]int
+}
+
+func newMemoryVisitStore(retention time.Duration) *memoryVisitStore {
+	return &memoryVisitStore{retention: retention, counted: map[string]map[string][]string{}, counts: map[string]map[string]int{}}
+}
+
+func (s *memoryVisitStore) count(_ context.Context, day, zoneID, visitor string, limit int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	oldest := time.Now().Add(-s.retention).Format(time.DateOnly)
+	for stored := range s.counts {
+		if stored < oldest {
+			delete(s.counts, stored)
+			delete(s.counted, stored)
+		}
+	}
+	if s.counted[day] == nil {
+		s.counted[day], s.counts[day] = map[string][]string{}, map[string]int{}
+	}
+	zones := s.counted[day][visitor]
+	if slices.Contains(zones, zoneID) || len(zones) >= limit {
+		return nil
+	}
+	s.counted[day][visitor] = append(zones, zoneID)
+	s.counts[day][zoneID]++
+	return nil
+}
+
+func (s *memoryVisitStore) visits(_ context.Context, day string) (map[string]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return maps.Clone(s.counts[day]), nil
+}
+
+// analyticsCSVHeader matches the rows of analyticsTable
+var analyticsCSVHeader = []string{"date", "zone_id", "zone_name", "visitors", "incidents", "incidents_per_1000_visitors"}
+
+// analyticsTable renders rows for CSV and XLSX, with withheld counts left empty
+func analyticsTable(rows []AnalyticsRow) [][]string {
+	table := make([][]string, 0, len(rows))
+	for _, row := range rows {
+		cells := []string{row.Date, row.ZoneID, row.ZoneName, "", "", ""}
+		if row.Visitors != nil {
+			cells[3] = strconv.Itoa(*row.Visitors)
+		}
+		if row.Incidents != nil {
+			cells[4] = strconv.Itoa(*row.Incidents)
+		}
+		if row.IncidentsPer1000 != nil {
+			cells[5] = strconv.FormatFloat(*row.IncidentsPer1000, 'f', 2, 64)
+		}
+		table = append(table, cells)
+	}
+	return table
+}
+
+// getZoneAnalytics returns the privacy-protected visitor and incident counts of every zone
+func getZoneAnalytics(c *gin.Context) {
+	var req AnalyticsRequest
+	if !bindQuery(c, &req) || !analyticsEnabled(c) {
+		return
+	}
+	export, err := analytics.export(c.Request.Context(), req, time.Now())
+	if err != nil {
+		respondServiceError(c, "Failed to compute zone analytics", err)
+		return
+	}
+	respondData(c, http.StatusOK, export)
+}
+
+// exportZoneAnalytics downloads the same counts as CSV or XLSX, for publication as open data
+func exportZoneAnalytics(c *gin.Context) {
+	var req AnalyticsExportRequest
+	if !bindQuery(c, &req) || !analyticsEnabled(c) {
+		return
+	}
+	streamExport(c, "analytics", req.Format, analyticsCSVHeader, func(string) ([][]string, string, error) {
+		export, err := analytics.export(c.Request.Context(), AnalyticsRequest{From: req.From, To: req.To}, time.Now())
+		return analyticsTable(export.Rows), "", err
+	})
+}
+
+func analyticsEnabled(c *gin.Context) bool {
+	if analytics == nil {
+		respondError(c, http.StatusServiceUnavailable, errCodeAnalyticsDisabled, "Zone analytics are not enabled; set ANALYTICS_SECRET")
+		return false
+	}
+	return true
+}
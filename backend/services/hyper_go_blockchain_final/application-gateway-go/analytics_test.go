@@ -0,0 +1,106 @@
+package gateway
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAnalyticsNoise(t *testing.T) {
+	a := &analyticsExporter{secret: []byte(strings.Repeat("k", 32)), maxZones: 1}
+	if a.noise("visitors", "2026-10-01", "falls", 1) != a.noise("visitors", "2026-10-01", "falls", 1) {
+		t.Fatal("expected the same cell to draw the same noise")
+	}
+	// Discrete Laplace with ε=1 is symmetric about zero, with mean absolute value about 0.92
+	sum, abs, n := 0, 0, 4000
+	for i := range n {
+		x := a.noise("visitors", "2026-10-01", "zone-"+strconv.Itoa(i), 1)
+		sum += x
+		abs += max(x, -x)
+	}
+	if mean, meanAbs := float64(sum)/float64(n), float64(abs)/float64(n); mean < -0.15 || mean > 0.15 || meanAbs < 0.75 || meanAbs > 1.1 {
+		t.Errorf("unexpected noise distribution: mean %.3f, mean absolute %.3f", mean, meanAbs)
+	}
+}
+
+func TestAnalyticsExport(t *testing.T) {
+	previous := geofence
+	geofence = newGeofenceIndex()
+	defer func() { geofence = previous }()
+	square := `{"type":"Polygon","coordinates":[[[91.81,25.53],[91.83,25.53],[91.83,25.55],[91.81,25.55],[91.81,25.53]]]}`
+	for _, doc := range []ZoneDocument{
+		{ZoneID: "police-bazar", Name: "Police Bazar", Geometry: square, RiskLevel: "low"},
+		{ZoneID: "laitlum", Name: "Laitlum", Geometry: `{"type":"Circle","coordinates":[91.95,25.45],"radius":500}`, RiskLevel: "medium"},
+	} {
+		z, err := newIndexedZone(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		geofence.put(z)
+	}
+
+	ctx := context.Background()
+	geohash := encodeGeohash(25.54, 91.82, 6)
+	var incidents []IncidentDocument
+	for i := range 400 {
+		// Every incident about the same tourist counts once
+		subject := "did:sih:s" + strconv.Itoa(i%200)
+		incidents = append(incidents, IncidentDocument{IncidentID: "INC-" + strconv.Itoa(i), SubjectID: subject, Geohash: geohash, CreatedAt: "2026-10-01T06:00:00Z"})
+	}
+	a := &analyticsExporter{
+		store:     newMemoryVisitStore(24 * time.Hour * 400),
+		incidents: func(context.Context, time.Time, time.Time) ([]IncidentDocument, error) { return incidents, nil },
+		location:  time.UTC,
+		secret:    []byte(strings.Repeat("k", 32)),
+		epsilon:   20,
+		maxZones:  1,
+		minCount:  10,
+	}
+	for i := range 1000 {
+		did := "did:sih:t" + strconv.Itoa(i)
+		a.recordVisits(ctx, []GeofenceEvent{
+			{Type: geofenceZoneEntry, DigitalID: did, ZoneID: "police-bazar", At: "2026-10-01T09:00:00Z"},
+			{Type: geofenceZoneEntry, DigitalID: did, ZoneID: "police-bazar", At: "2026-10-01T15:00:00Z"},
+			// Beyond the one zone a day each tourist may count in
+			{Type: geofenceZoneEntry, DigitalID: did, ZoneID: "laitlum", At: "2026-10-01T16:00:00Z"},
+		})
+	}
+	for i := range 3 {
+		a.recordVisits(ctx, []GeofenceEvent{{Type: geofenceZoneEntry, DigitalID: "did:sih:u" + strconv.Itoa(i), ZoneID: "laitlum", At: "2026-10-02T09:00:00Z"}})
+	}
+
+	now := time.Date(2026, 10, 3, 0, 0, 0, 0, time.UTC)
+	export, err := a.export(ctx, AnalyticsRequest{From: "2026-10-01", To: "2026-10-02"}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(export.Rows) != 4 {
+		t.Fatalf("expected a row for each zone on each day, got %+v", export.Rows)
+	}
+	// Rows are by day, then zone ID
+	bazar := export.Rows[1]
+	if bazar.ZoneID != "police-bazar" || bazar.Visitors == nil || *bazar.Visitors < 990 || *bazar.Visitors > 1010 {
+		t.Errorf("expected about 1000 visitors to Police Bazar, got %+v", bazar)
+	}
+	if bazar.Incidents == nil || *bazar.Incidents < 190 || *bazar.Incidents > 210 || bazar.IncidentsPer1000 == nil {
+		t.Errorf("expected about 200 people in incidents with a rate, got %+v", bazar)
+	}
+	for _, row := range []AnalyticsRow{export.Rows[0], export.Rows[2], export.Rows[3]} {
+		if row.Visitors != nil || row.Incidents != nil || row.IncidentsPer1000 != nil {
+			t.Errorf("expected counts below the threshold withheld, got %+v", row)
+		}
+	}
+
+	again, _ := a.export(ctx, AnalyticsRequest{From: "2026-10-01", To: "2026-10-01"}, now)
+	if *again.Rows[1].Visitors != *bazar.Visitors {
+		t.Error("expected a day exported again to give the same counts")
+	}
+	if _, err := a.export(ctx, AnalyticsRequest{From: "2026-10-01", To: "2026-10-03"}, now); err == nil {
+		t.Error("expected a day that has not ended to be refused")
+	}
+	if table := analyticsTable(export.Rows); table[0][3] != "" || table[1][1] != "police-bazar" {
+		t.Errorf("expected withheld counts left empty, got %v", table)
+	}
+}
@@ -0,0 +1,490 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	errCodeAnomaliesDisabled = "ANOMALIES_DISABLED"
+
+	anomalyStatePrefix = "sih:anomaly:state:"
+	anomalyWatchKey    = "sih:anomaly:watch"
+	anomalyReporter    = "anomaly-detector"
+
+	anomalyRouteDeviation = "route_deviation"
+	anomalyInactivity     = "inactivity"
+	anomalySignalLoss     = "signal_loss"
+	anomalySpeedJump      = "speed_jump"
+)
+
+// AnomalyEvent is unusual behaviour seen in a tourist's pings. Score runs from 0 to 1 and grows
+// with how far past its threshold the behaviour is and with the risk of the zone it happened in.
+type AnomalyEvent struct {
+	AnomalyID string  `json:"anomaly_id"`
+	Type      string  `json:"type"`
+	DigitalID string  `json:"digital_id"`
+	Score     float64 `json:"score"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	At        string  `json:"at"`
+	ZoneID    string  `json:"zone_id,omitempty"`
+	RiskLevel string  `json:"risk_level,omitempty"`
+	Detail    string  `json:"detail"`
+	// DeviationKm and DeviationSeconds measure a route deviation so far
+	DeviationKm      float64 `json:"deviation_km,omitempty"`
+	DeviationSeconds int64   `json:"deviation_seconds,omitempty"`
+}
+
+// anomalyState is what the detector remembers about a tourist between uploads
+type anomalyState struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Accuracy  float64 `json:"accuracy"`
+	At        string  `json:"at"`
+	// ZoneID and RiskLevel are the riskiest active zone at the last ping
+	ZoneID    string `json:"zone_id,omitempty"`
+	RiskLevel string `json:"risk_level,omitempty"`
+	// The tourist has stayed within ANOMALY_STILL_RADIUS_M of the still position since StillSince
+	StillLatitude  float64 `json:"still_latitude"`
+	StillLongitude float64 `json:"still_longitude"`
+	StillSince     string  `json:"still_since,omitempty"`
+	Inactive       bool    `json:"inactive,omitempty"`
+	Deviated       bool    `json:"deviated,omitempty"`
+	// DeviatedSince is the first ping of the current deviation; Prolonged is set once it has been
+	// reported as lasting ANOMALY_DEVIATION_DURATION
+	DeviatedSince string `json:"deviated_since,omitempty"`
+	Prolonged     bool   `json:"prolonged,omitempty"`
+}
+
+// anomalyStore keeps detector state per tourist and the tourists whose last ping was in a high-risk
+// zone, so a sweep can notice when they fall silent
+type anomalyStore interface {
+	load(ctx context.Context, digitalID string) (*anomalyState, error)
+	save(ctx context.Context, digitalID string, state anomalyState) error
+	watch(ctx context.Context, digitalID string, lastAt time.Time) error
+	unwatch(ctx context.Context, digitalID string) error
+	// silent removes and returns the watched tourists whose last ping was before the cutoff
+	silent(ctx context.Context, before time.Time) ([]string, error)
+}
+
+// anomalyDetector scores location streams for route deviation, inactivity, signal loss and speed
+// jumps, and raises the events as incidents or push alerts
+type anomalyDetector struct {
+	store       anomalyStore
+	itineraries itineraryStore
+
+	corridorKm       float64
+	deviationAfter   time.Duration
+	inactivity       time.Duration
+	stillRadius      float64 // metres
+	signalLoss       time.Duration
+	maxSpeedKmh      float64
+	incidentMinScore float64
+	notifyMinScore   float64
+}
+
+var anomalies *anomalyDetector
+
+// initAnomalies runs after initDocumentCache and initGeofence
+func initAnomalies() {
+	if !getEnvBool("ANOMALY_DETECTION_ENABLED", false) {
+		log.Println("🧭 ANOMALY_DETECTION_ENABLED not set, anomaly detection disabled")
+		return
+	}
+	ttl := getEnvDuration("ANOMALY_STATE_TTL", 24*time.Hour)
+	var store anomalyStore = newMemoryAnomalyStore(ttl)
+	var itineraries itineraryStore = newMemoryItineraryStore()
+	backend := "memory"
+	if documentCache != nil {
+		store, itineraries, backend = redisAnomalyStore{documentCache, ttl}, redisItineraryStore{documentCache}, "Redis"
+	}
+	anomalies = &anomalyDetector{
+		store:            store,
+		itineraries:      itineraries,
+		corridorKm:       getEnvFloat("ANOMALY_ROUTE_CORRIDOR_KM", 2),
+		deviationAfter:   getEnvDuration("ANOMALY_DEVIATION_DURATION", time.Hour),
+		inactivity:       getEnvDuration("ANOMALY_INACTIVITY", 2*time.Hour),
+		stillRadius:      getEnvFloat("ANOMALY_STILL_RADIUS_M", 100),
+		signalLoss:       getEnvDuration("ANOMALY_SIGNAL_LOSS", 15*time.Minute),
+		maxSpeedKmh:      getEnvFloat("ANOMALY_MAX_SPEED_KMH", 250),
+		incidentMinScore: getEnvFloat("ANOMALY_INCIDENT_MIN_SCORE", 0.8),
+		notifyMinScore:   getEnvFloat("ANOMALY_NOTIFY_MIN_SCORE", 0.5),
+	}
+	if anomalies.corridorKm <= 0 || anomalies.corridorKm > maxItineraryCorridor {
+		panic(fmt.Errorf("ANOMALY_ROUTE_CORRIDOR_KM must be between 0 and %d", maxItineraryCorridor))
+	}
+	if anomalies.inactivity <= 0 || anomalies.signalLoss <= 0 || anomalies.stillRadius <= 0 || anomalies.maxSpeedKmh <= 0 {
+		panic(fmt.Errorf("ANOMALY_INACTIVITY, ANOMALY_SIGNAL_LOSS, ANOMALY_STILL_RADIUS_M and ANOMALY_MAX_SPEED_KMH must be positive"))
+	}
+	log.Printf("🧭 Detecting anomalies with state in %s; incidents from score %.2f, push alerts from %.2f",
+		backend, anomalies.incidentMinScore, anomalies.notifyMinScore)
+}
+
+// anomalyScore puts the ratio of a measurement to its threshold on a scale where the threshold is
+// 0.5 and twice the threshold is 1, then adds the zone's risk
+func anomalyScore(ratio float64, riskLevel string) float64 {
+	score := 0.5*ratio + 0.1*float64(max(zoneRiskLevel(riskLevel), 0))
+	return math.Round(math.Min(score, 1)*100) / 100
+}
+
+// anomalyID is stable for an event, so instances that see the same pings raise it once
+func anomalyID(digitalID, kind string, at time.Time) string {
+	sum := sha256.Sum256([]byte(digitalID + "|" + kind))
+	return "ANOM-" + at.UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(sum[:5])
+}
+
+// observe runs the pings through the detectors in recording order. Pings no later than the last one
+// seen are skipped, so retried uploads do not repeat events.
+func (d *anomalyDetector) observe(ctx context.Context, digitalID string, pings []LocationPing) ([]AnomalyEvent, error) {
+	state, err := d.store.load(ctx, digitalID)
+	if err != nil {
+		return nil, err
+	}
+	itinerary, err := d.itineraries.get(ctx, digitalID)
+	if err != nil {
+		return nil, err
+	}
+
+	ordered := append([]LocationPing(nil), pings...)
+	sort.SliceStable(ordered, func(i, j int) bool { return ordered[i].RecordedAt < ordered[j].RecordedAt })
+
+	var events []AnomalyEvent
+	seen := false
+	for _, ping := range ordered {
+		at, _ := time.Parse(time.RFC3339, ping.RecordedAt)
+		var last time.Time
+		if state != nil {
+			if last, _ = time.Parse(time.RFC3339, state.At); !at.After(last) {
+				continue
+			}
+		}
+		seen = true
+		lat, lng := *ping.Latitude, *ping.Longitude
+		zoneID, riskLevel := riskiestZone(lat, lng, at)
+		event := func(kind string, score float64, detail string) *AnomalyEvent {
+			events = append(events, AnomalyEvent{
+				AnomalyID: anomalyID(digitalID, kind, at), Type: kind, DigitalID: digitalID, Score: score,
+				Latitude: lat, Longitude: lng, At: at.UTC().Format(time.RFC3339), ZoneID: zoneID, RiskLevel: riskLevel, Detail: detail,
+			})
+			return &events[len(events)-1]
+		}
+
+		if state == nil {
+			state = &anomalyState{StillLatitude: lat, StillLongitude: lng, StillSince: ping.RecordedAt}
+		} else {
+			// Jitter within the two fixes' accuracy is not movement
+			km := haversineKm(state.Latitude, state.Longitude, lat, lng)
+			if hours := at.Sub(last).Hours(); km > 0.5+(state.Accuracy+ping.Accuracy)/1000 {
+				if speed := km / hours; speed > d.maxSpeedKmh {
+					event(anomalySpeedJump, anomalyScore(speed/d.maxSpeedKmh, riskLevel), fmt.Sprintf("Moved %.1f km in %s, %.0f km/h", km, at.Sub(last).Round(time.Second), speed))
+				}
+			}
+		}
+
+		if itinerary != nil && itinerary.activeAt(at) {
+			km, limit, stop := itinerary.deviation(lat, lng, at)
+			from := "the declared route"
+			if stop != "" {
+				from = "the planned stay at " + stop
+			}
+			switch {
+			case km <= limit:
+				state.Deviated, state.DeviatedSince, state.Prolonged = false, "", false
+			case !state.Deviated:
+				state.Deviated, state.DeviatedSince = true, at.UTC().Format(time.RFC3339)
+				e := event(anomalyRouteDeviation, anomalyScore(km/limit, riskLevel), fmt.Sprintf("%.1f km from %s", km, from))
+				e.DeviationKm = math.Round(km*100) / 100
+			default:
+				// A deviation lasting ANOMALY_DEVIATION_DURATION is reported again, scored by
+				// whichever of its distance and duration is further past its threshold
+				since, _ := time.Parse(time.RFC3339, state.DeviatedSince)
+				if lasted := at.Sub(since); d.deviationAfter > 0 && !state.Prolonged && state.DeviatedSince != "" && lasted >= d.deviationAfter {
+					state.Prolonged = true
+					ratio := math.Max(km/limit, float64(lasted)/float64(d.deviationAfter))
+					e := event(anomalyRouteDeviation, anomalyScore(ratio, riskLevel), fmt.Sprintf("%.1f km from %s for %s", km, from, lasted.Round(time.Minute)))
+					e.DeviationKm, e.DeviationSeconds = math.Round(km*100)/100, int64(lasted.Seconds())
+				}
+			}
+		}
+
+		stillSince, _ := time.Parse(time.RFC3339, state.StillSince)
+		if haversineKm(state.StillLatitude, state.StillLongitude, lat, lng)*1000 > math.Max(d.stillRadius, ping.Accuracy) {
+			state.StillLatitude, state.StillLongitude, state.StillSince, state.Inactive = lat, lng, at.UTC().Format(time.RFC3339), false
+		} else if still := at.Sub(stillSince); !state.Inactive && still >= d.inactivity {
+			state.Inactive = true
+			event(anomalyInactivity, anomalyScore(float64(still)/float64(d.inactivity), riskLevel), fmt.Sprintf("No movement for %s", still.Round(time.Minute)))
+		}
+
+		state.Latitude, state.Longitude, state.Accuracy, state.At = lat, lng, ping.Accuracy, at.UTC().Format(time.RFC3339)
+		state.ZoneID, state.RiskLevel = zoneID, riskLevel
+	}
+	if !seen {
+		return events, nil
+	}
+
+	if zoneRiskLevel(state.RiskLevel) >= zoneRiskLevel("high") {
+		last, _ := time.Parse(time.RFC3339, state.At)
+		err = d.store.watch(ctx, digitalID, last)
+	} else {
+		err = d.store.unwatch(ctx, digitalID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return events, d.store.save(ctx, digitalID, *state)
+}
+
+// riskiestZone returns the active zone with the highest risk level at a position
+func riskiestZone(lat, lng float64, at time.Time) (string, string) {
+	if geofence == nil {
+		return "", ""
+	}
+	evaluation := geofence.evaluate(lat, lng, at)
+	for _, zone := range evaluation.Zones {
+		if zone.Active && zone.RiskLevel == evaluation.RiskLevel {
+			return zone.ZoneID, zone.RiskLevel
+		}
+	}
+	return "", ""
+}
+
+// run sweeps for tourists who went silent in a high-risk zone. Each silent tourist is taken off the
+// watch list by exactly one gateway instance, which raises the event.
+func (d *anomalyDetector) run(ctx context.Context) {
+	ticker := time.NewTicker(min(d.signalLoss/4, time.Minute))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			events, err := d.sweep(ctx, time.Now())
+			if err != nil {
+				log.Printf("🧭 Failed to sweep for signal loss: %v", err)
+			}
+			d.raise(context.Background(), events)
+			if safety != nil {
+				safety.observe(ctx, events)
+			}
+		}
+	}
+}
+
+func (d *anomalyDetector) sweep(ctx context.Context, now time.Time) ([]AnomalyEvent, error) {
+	silent, err := d.store.silent(ctx, now.Add(-d.signalLoss))
+	if err != nil {
+		return nil, err
+	}
+	var events []AnomalyEvent
+	for _, digitalID := range silent {
+		state, err := d.store.load(ctx, digitalID)
+		if err != nil {
+			return events, err
+		}
+		if state == nil {
+			continue
+		}
+		last, _ := time.Parse(time.RFC3339, state.At)
+		events = append(events, AnomalyEvent{
+			AnomalyID: anomalyID(digitalID, anomalySignalLoss, last), Type: anomalySignalLoss, DigitalID: digitalID,
+			Score:    anomalyScore(float64(now.Sub(last))/float64(d.signalLoss), state.RiskLevel),
+			Latitude: state.Latitude, Longitude: state.Longitude, At: state.At, ZoneID: state.ZoneID, RiskLevel: state.RiskLevel,
+			Detail: fmt.Sprintf("No location for %s in a %s risk zone", now.Sub(last).Round(time.Minute), state.RiskLevel),
+		})
+	}
+	return events, nil
+}
+
+func anomalySeverity(score float64) string {
+	switch {
+	case score >= 0.9:
+		return "critical"
+	case score >= 0.75:
+		return "high"
+	case score >= 0.5:
+		return "medium"
+	}
+	return "low"
+}
+
+// raise records events scoring ANOMALY_INCIDENT_MIN_SCORE or more as incidents, which reach push
+// and the dashboards through the event stream, and pushes those scoring ANOMALY_NOTIFY_MIN_SCORE or
+// more directly. Tour operators hear of every event in their groups. The detector reports as the
+// gateway, not as the caller whose upload tripped it. What was decided for each event is anchored
+// with the features it was decided on.
+func (d *anomalyDetector) raise(ctx context.Context, events []AnomalyEvent) {
+	if operators != nil {
+		operators.anomalies(ctx, events)
+	}
+	for _, e := range events {
+		log.Printf("🧭 %s anomaly for %s scored %.2f: %s", e.Type, e.DigitalID, e.Score, e.Detail)
+		switch {
+		case e.Score >= d.incidentMinScore:
+			summary, _ := json.Marshal(e)
+			sum := sha256.Sum256(summary)
+			lat, lng := e.Latitude, e.Longitude
+			_, err := ledger.CreateIncident(ctx, CreateIncidentRequest{
+				IncidentID:          e.AnomalyID,
+				IncidentSummaryHash: hex.EncodeToString(sum[:]),
+				Reporter:            anomalyReporter,
+				Severity:            anomalySeverity(e.Score),
+				Category:            "anomaly",
+				DigitalID:           e.DigitalID,
+				Latitude:            &lat,
+				Longitude:           &lng,
+			})
+			if err != nil && translateFabricError(err).Code != errCodeAlreadyExists {
+				log.Printf("🧭 Failed to raise incident for anomaly %s: %v", e.AnomalyID, err)
+			}
+			decisions.anomaly(ctx, d, e, decisionIncident)
+		case e.Score >= d.notifyMinScore && pusher != nil:
+			pusher.deliver(ctx, pushAlert{
+				key:   "anomaly:" + e.AnomalyID,
+				zone:  e.ZoneID,
+				title: "Unusual tourist activity",
+				body:  "Tourist " + e.DigitalID + ": " + e.Detail,
+				data:  map[string]string{"type": "anomaly", "anomaly": e.Type, "anomaly_id": e.AnomalyID, "digital_id": e.DigitalID, "score": strconv.FormatFloat(e.Score, 'f', 2, 64), "at": e.At},
+			})
+			decisions.anomaly(ctx, d, e, decisionPush)
+		default:
+			decisions.anomaly(ctx, d, e, decisionNoAction)
+		}
+	}
+}
+
+// redisAnomalyStore keeps state under a key per tourist and the watch list in a sorted set scored
+// by the time of the last ping
+type redisAnomalyStore struct {
+	redis *redisClient
+	ttl   time.Duration
+}
+
+func (s redisAnomalyStore) load(ctx context.Context, digitalID string) (*anomalyState, error) {
+	data, err := s.redis.Get(ctx, anomalyStatePrefix+digitalID)
+	if errors.Is(err, errRedisNil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state anomalyState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+func (s redisAnomalyStore) save(ctx context.Context, digitalID string, state anomalyState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return s.redis.Set(ctx, anomalyStatePrefix+digitalID, data, s.ttl)
+}
+
+func (s redisAnomalyStore) watch(ctx context.Context, digitalID string, lastAt time.Time) error {
+	_, err := s.redis.Do(ctx, "ZADD", anomalyWatchKey, strconv.FormatInt(lastAt.UnixMilli(), 10), digitalID)
+	return err
+}
+
+func (s redisAnomalyStore) unwatch(ctx context.Context, digitalID string) error {
+	_, err := s.redis.Do(ctx, "ZREM", anomalyWatchKey, digitalID)
+	return err
+}
+
+// silent claims each expired member with ZREM, so only one instance reports it
+func (s redisAnomalyStore) silent(ctx context.Context, before time.Time) ([]string, error) {
+	reply, err := s.redis.Do(ctx, "ZRANGEBYSCORE", anomalyWatchKey, "-inf", strconv.FormatInt(before.UnixMilli(), 10), "LIMIT", "0", "100")
+	if err != nil {
+		return nil, err
+	}
+	members, _ := reply.([]interface{})
+	var silent []string
+	for _, member := range members {
+		raw, _ := member.([]byte)
+		removed, err := s.redis.Do(ctx, "ZREM", anomalyWatchKey, string(raw))
+		if err != nil {
+			return silent, err
+		}
+		if n, _ := removed.(int64); n == 1 {
+			silent = append(silent, string(raw))
+		}
+	}
+	return silent, nil
+}
+
+// memoryAnomalyStore serves a single gateway instance
+type memoryAnomalyStore struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	states  map[string]anomalyState
+	savedAt map[string]time.Time
+	watched map[string]time.Time
+}
+
+func newMemoryAnomalyStore(ttl time.Duration) *memoryAnomalyStore {
+	return &memoryAnomalyStore{ttl: ttl, states: map[string]anomalyState{}, savedAt: map[string]time.Time{}, watched: map[string]time.Time{}}
+}
+
+func (s *memoryAnomalyStore) load(_ context.Context, digitalID string) (*anomalyState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state, ok := s.states[digitalID]
+	if !ok || time.Since(s.savedAt[digitalID]) > s.ttl {
+		return nil, nil
+	}
+	return &state, nil
+}
+
+func (s *memoryAnomalyStore) save(_ context.Context, digitalID string, state anomalyState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.states[digitalID], s.savedAt[digitalID] = state, time.Now()
+	return nil
+}
+
+func (s *memoryAnomalyStore) watch(_ context.Context, digitalID string, lastAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.watched[digitalID] = lastAt
+	return nil
+}
+
+func (s *memoryAnomalyStore) unwatch(_ context.Context, digitalID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.watched, digitalID)
+	return nil
+}
+
+func (s *memoryAnomalyStore) silent(_ context.Context, before time.Time) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var silent []string
+	for digitalID, lastAt := range s.watched {
+		if lastAt.Before(before) {
+			silent = append(silent, digitalID)
+			delete(s.watched, digitalID)
+		}
+	}
+	sort.Strings(silent)
+	return silent, nil
+}
@@ -0,0 +1,141 @@
+package gateway
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestAnomalyDetection(t *testing.T) {
+	previous := geofence
+	geofence = newGeofenceIndex()
+	defer func() { geofence = previous }()
+	gorge, err := newIndexedZone(ZoneDocument{ZoneID: "ZONE-GORGE", Name: "Gorge", Geometry: `{"type":"Circle","coordinates":[91.95,25.60],"radius":500}`, RiskLevel: "restricted"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	geofence.put(gorge)
+
+	d := &anomalyDetector{
+		store: newMemoryAnomalyStore(time.Hour), itineraries: newMemoryItineraryStore(),
+		corridorKm: 2, inactivity: time.Hour, stillRadius: 100, signalLoss: 15 * time.Minute, maxSpeedKmh: 250,
+	}
+	ctx := context.Background()
+	id := "did:sih:tourist_001"
+	start := time.Now().Add(-4 * time.Hour).UTC().Truncate(time.Second)
+	ping := func(offset time.Duration, lat, lng float64) LocationPing {
+		return LocationPing{Latitude: &lat, Longitude: &lng, Accuracy: 10, RecordedAt: start.Add(offset).Format(time.RFC3339)}
+	}
+	stop := func(lat, lng float64) ItineraryStop { return ItineraryStop{Latitude: &lat, Longitude: &lng} }
+	d.itineraries.put(ctx, Itinerary{
+		DigitalID: id, Stops: []ItineraryStop{stop(25.5788, 91.8933), stop(25.5788, 92.0)}, CorridorKm: 2,
+		StartsAt: start.Format(time.RFC3339), EndsAt: start.Add(8 * time.Hour).Format(time.RFC3339),
+	}, time.Hour)
+
+	types := func(events []AnomalyEvent) []string {
+		var kinds []string
+		for _, e := range events {
+			kinds = append(kinds, e.Type)
+		}
+		return kinds
+	}
+
+	// On the route, then 5 km off it: one deviation for the episode, however long it lasts
+	events, err := d.observe(ctx, id, []LocationPing{
+		ping(0, 25.5788, 91.8933), ping(20*time.Minute, 25.5788, 91.92), ping(40*time.Minute, 25.6238, 91.93), ping(50*time.Minute, 25.6240, 91.93),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kinds := types(events); len(kinds) != 1 || kinds[0] != anomalyRouteDeviation || events[0].Score < 1 {
+		t.Fatalf("expected one route deviation at full score, got %+v", events)
+	}
+
+	// A retried upload repeats nothing; an hour in place is inactivity, and a hop of 30 km in five
+	// minutes is a speed jump
+	if events, _ := d.observe(ctx, id, []LocationPing{ping(50*time.Minute, 25.6240, 91.93)}); len(events) != 0 {
+		t.Errorf("expected a retried ping skipped, got %+v", events)
+	}
+	events, _ = d.observe(ctx, id, []LocationPing{ping(100*time.Minute, 25.6241, 91.9301), ping(105*time.Minute, 25.5788, 92.22)})
+	if kinds := types(events); len(kinds) != 2 || kinds[0] != anomalyInactivity || kinds[1] != anomalySpeedJump {
+		t.Fatalf("expected inactivity then a speed jump, got %+v", events)
+	}
+	if events[0].AnomalyID == events[1].AnomalyID {
+		t.Error("expected distinct anomaly IDs")
+	}
+
+	// Falling silent in the restricted zone is a signal loss, reported by one sweep only
+	if _, err := d.observe(ctx, id, []LocationPing{ping(110*time.Minute, 25.60, 91.95)}); err != nil {
+		t.Fatal(err)
+	}
+	lost, err := d.sweep(ctx, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lost) != 1 || lost[0].Type != anomalySignalLoss || lost[0].ZoneID != "ZONE-GORGE" || lost[0].Score != 1 {
+		t.Fatalf("expected a signal loss in the gorge, got %+v", lost)
+	}
+	if again, _ := d.sweep(ctx, time.Now()); len(again) != 0 {
+		t.Errorf("expected the signal loss reported once, got %+v", again)
+	}
+	if anomalySeverity(lost[0].Score) != "critical" || anomalySeverity(0.6) != "medium" {
+		t.Error("unexpected severity mapping")
+	}
+}
+
+func TestItineraryDeviation(t *testing.T) {
+	d := &anomalyDetector{
+		store: newMemoryAnomalyStore(time.Hour), itineraries: newMemoryItineraryStore(),
+		corridorKm: 2, deviationAfter: 30 * time.Minute, inactivity: 24 * time.Hour, stillRadius: 100, signalLoss: 15 * time.Minute, maxSpeedKmh: 250,
+	}
+	ctx := context.Background()
+	id := "did:sih:tourist_002"
+	start := time.Now().Add(-4 * time.Hour).UTC().Truncate(time.Second)
+	at := func(offset time.Duration) string { return start.Add(offset).Format(time.RFC3339) }
+	ping := func(offset time.Duration, lat, lng float64) LocationPing {
+		return LocationPing{Latitude: &lat, Longitude: &lng, Accuracy: 10, RecordedAt: at(offset)}
+	}
+	lat1, lng1, lat2, lng2 := 25.5788, 91.8933, 25.5788, 92.0
+	req := ItineraryRequest{
+		Stops: []ItineraryStop{
+			{Name: "Police Bazar", Latitude: &lat1, Longitude: &lng1},
+			{Name: "Ward's Lake", Latitude: &lat2, Longitude: &lng2, ArriveAt: at(time.Hour), DepartAt: at(3 * time.Hour), RadiusKm: 0.5},
+		},
+		StartsAt: at(0), EndsAt: at(8 * time.Hour),
+	}
+	if errs := req.Validate(); len(errs) > 0 {
+		t.Fatalf("expected a valid itinerary, got %v", errs)
+	}
+	it := Itinerary{DigitalID: id, Stops: req.Stops, StartsAt: req.StartsAt, EndsAt: req.EndsAt, CorridorKm: 2}
+	if itineraryDigest(it) != itineraryDigest(it) || len(itineraryDigest(it)) != 64 {
+		t.Fatal("expected a stable SHA-256 digest")
+	}
+	d.itineraries.put(ctx, it, time.Hour)
+
+	// On the route at first, but 1.5 km short of Ward's Lake once the stay starts: a deviation from
+	// the stay point, reported again once it has lasted half an hour
+	events, err := d.observe(ctx, id, []LocationPing{
+		ping(30*time.Minute, 25.5788, 91.985), ping(70*time.Minute, 25.5788, 91.985), ping(90*time.Minute, 25.5789, 91.985), ping(105*time.Minute, 25.5788, 91.9851),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].DeviationKm < 1 || events[1].DeviationSeconds != 35*60 {
+		t.Fatalf("expected a stay deviation reported twice, got %+v", events)
+	}
+
+	live := &LiveLocation{DigitalID: id, Latitude: 25.5788, Longitude: 91.9851, RecordedAt: at(105 * time.Minute)}
+	deviation, err := d.deviation(ctx, &it, live)
+	if err != nil || !deviation.Deviated || deviation.ExpectedStop != "Ward's Lake" || deviation.LimitKm != 0.5 || deviation.DeviationSeconds != 35*60 {
+		t.Errorf("unexpected deviation %+v, %v", deviation, err)
+	}
+
+	// Arriving ends the deviation
+	if events, _ := d.observe(ctx, id, []LocationPing{ping(110*time.Minute, 25.5790, 92.0)}); len(events) != 0 {
+		t.Errorf("expected no events on arrival, got %+v", events)
+	}
+	live.Longitude, live.RecordedAt = 92.0, at(110*time.Minute)
+	if deviation, _ := d.deviation(ctx, &it, live); deviation.Deviated || deviation.DeviatedSince != "" {
+		t.Errorf("expected the tourist at the stay point, got %+v", deviation)
+	}
+}
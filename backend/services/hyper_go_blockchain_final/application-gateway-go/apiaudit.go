@@ -0,0 +1,373 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// apiAuditTargetKey holds the document a mutation acts on when its route has no ID parameter
+	apiAuditTargetKey = "auditTarget"
+	maxAPIAuditsPerTx = 500
+)
+
+var apiAuditMethods = map[string]bool{"POST": true, "PUT": true, "PATCH": true, "DELETE": true}
+
+// APIAuditEntry is one mutation handled by the REST or gRPC API, recorded on the ledger by
+// RecordAPIAudits as an audit entry with action API_<method>
+type APIAuditEntry struct {
+	RequestID string `json:"request_id"`
+	// Actor identifies the caller by wallet label, API key hash, token subject or client IP
+	Actor  string `json:"actor"`
+	Method string `json:"method"`
+	// Route is the route template, such as /api/v1/incident/:id, or the full gRPC method
+	Route    string `json:"route"`
+	TargetID string `json:"target_id"`
+	// RequestHash is the idempotency fingerprint: SHA-256 of the method, path, target and body
+	RequestHash string `json:"request_hash"`
+	// Status is the HTTP status or gRPC code of the response
+	Status     string `json:"status"`
+	ReceivedAt string `json:"received_at"`
+}
+
+// apiAuditor queues audit entries and records them in batches. Entries are batched per Fabric
+// target and signing identity, so each is recorded on the channel the mutation went to and
+// submitted by the caller's own organization.
+type apiAuditor struct {
+	batchSize     int
+	flushInterval time.Duration
+	maxPending    int
+
+	mu      sync.Mutex
+	batches map[apiAuditBatchKey]*apiAuditBatch
+	pending int
+	dropped int
+	full    chan struct{}
+}
+
+type apiAuditBatchKey struct {
+	target string
+	signer string
+}
+
+type apiAuditBatch struct {
+	target  *fabricTarget
+	signer  *walletIdentity
+	entries []APIAuditEntry
+}
+
+var apiAudits *apiAuditor
+
+// initAPIAudit runs after initWallet. Auditing is disabled with API_AUDIT_ENABLED=false.
+func initAPIAudit() {
+	if !getEnvBool("API_AUDIT_ENABLED", true) {
+		log.Println("🧾 API_AUDIT_ENABLED=false, API mutations are not audited on the ledger")
+		return
+	}
+	auditor := newAPIAuditor(
+		getEnvInt("API_AUDIT_BATCH_SIZE", 100),
+		getEnvDuration("API_AUDIT_FLUSH_INTERVAL", 5*time.Second),
+		getEnvInt("API_AUDIT_MAX_PENDING", 10000),
+	)
+	if auditor.batchSize < 1 || auditor.batchSize > maxAPIAuditsPerTx {
+		panic(fmt.Errorf("API_AUDIT_BATCH_SIZE must be between 1 and %d", maxAPIAuditsPerTx))
+	}
+	if auditor.flushInterval <= 0 {
+		panic(fmt.Errorf("API_AUDIT_FLUSH_INTERVAL must be positive"))
+	}
+	apiAudits = auditor
+	log.Printf("🧾 Auditing API mutations on the ledger in batches of up to %d every %s", auditor.batchSize, auditor.flushInterval)
+}
+
+func newAPIAuditor(batchSize int, flushInterval time.Duration, maxPending int) *apiAuditor {
+	return &apiAuditor{
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		maxPending:    maxPending,
+		batches:       map[apiAuditBatchKey]*apiAuditBatch{},
+		full:          make(chan struct{}, 1),
+	}
+}
+
+// record queues an entry for the request's target and signer. Once API_AUDIT_MAX_PENDING entries
+// are waiting, for example while the peers are down, further entries are dropped and counted.
+func (a *apiAuditor) record(ctx context.Context, entry APIAuditEntry) {
+	target := targetFromContext(ctx)
+	signer, _ := ctx.Value(signerContextKey{}).(*walletIdentity)
+	var key apiAuditBatchKey
+	if target != nil {
+		key.target = target.Name
+	}
+	if signer != nil {
+		key.signer = signer.Label
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.pending >= a.maxPending {
+		a.dropped++
+		return
+	}
+	batch, ok := a.batches[key]
+	if !ok {
+		batch = &apiAuditBatch{target: target, signer: signer}
+		a.batches[key] = batch
+	}
+	batch.entries = append(batch.entries, entry)
+	a.pending++
+	if len(batch.entries) >= a.batchSize {
+		select {
+		case a.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// run flushes every API_AUDIT_FLUSH_INTERVAL and whenever a batch fills. Entries still queued at
+// shutdown get one last attempt.
+func (a *apiAuditor) run(ctx context.Context) {
+	ticker := time.NewTicker(a.flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			final, cancel := context.WithTimeout(context.WithoutCancel(ctx), submitTimeout)
+			a.flush(final)
+			cancel()
+			return
+		case <-ticker.C:
+			a.flush(ctx)
+		case <-a.full:
+			a.flush(ctx)
+		}
+	}
+}
+
+// flush submits every queued batch. Batches whose transaction fails are queued again ahead of newer
+// entries; a retried batch that had in fact committed is harmless, since the chaincode skips
+// request IDs it has already recorded.
+func (a *apiAuditor) flush(ctx context.Context) {
+	a.mu.Lock()
+	batches := a.batches
+	dropped := a.dropped
+	a.batches = map[apiAuditBatchKey]*apiAuditBatch{}
+	a.pending, a.dropped = 0, 0
+	a.mu.Unlock()
+
+	if dropped > 0 {
+		log.Printf("🧾 Dropped %d API audit entries while the queue was full", dropped)
+	}
+	for key, batch := range batches {
+		for start := 0; start < len(batch.entries); start += a.batchSize {
+			chunk := batch.entries[start:min(start+a.batchSize, len(batch.entries))]
+			if err := a.submit(ctx, batch, chunk); err != nil {
+				log.Printf("Failed to record %d API audit entries on %s: %v", len(batch.entries)-start, key.target, err)
+				a.requeue(key, batch, batch.entries[start:])
+				break
+			}
+		}
+	}
+}
+
+func (a *apiAuditor) submit(ctx context.Context, batch *apiAuditBatch, entries []APIAuditEntry) error {
+	payload, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	_, err = submitTransaction(withSigner(withTarget(ctx, batch.target), batch.signer), "RecordAPIAudits", string(payload))
+	return err
+}
+
+func (a *apiAuditor) requeue(key apiAuditBatchKey, batch *apiAuditBatch, entries []APIAuditEntry) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if room := a.maxPending - a.pending; len(entries) > room {
+		a.dropped += len(entries) - max(room, 0)
+		entries = entries[:max(room, 0)]
+	}
+	if current, ok := a.batches[key]; ok {
+		current.entries = append(append([]APIAuditEntry(nil), entries...), current.entries...)
+	} else {
+		a.batches[key] = &apiAuditBatch{target: batch.target, signer: batch.signer, entries: entries}
+	}
+	a.pending += len(entries)
+}
+
+// apiAuditMiddleware queues an audit entry for every mutating request once it has been handled,
+// whatever its outcome, so rejected and failed attempts are visible on the ledger too
+func apiAuditMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if apiAudits == nil || !apiAuditMethods[c.Request.Method] {
+			c.Next()
+			return
+		}
+		receivedAt := time.Now().UTC()
+		fingerprint := newRequestFingerprint(c)
+		c.Request.Body = readCloser{io.TeeReader(c.Request.Body, fingerprint.hash), c.Request.Body}
+
+		c.Next()
+
+		io.Copy(io.Discard, c.Request.Body)
+		ctx := c.Request.Context()
+		apiAudits.record(ctx, APIAuditEntry{
+			RequestID:   newRequestID(ctx),
+			Actor:       apiActor(ctx, c.GetHeader(apiKeyHeader), c.GetHeader("Authorization"), c.ClientIP()),
+			Method:      c.Request.Method,
+			Route:       c.FullPath(),
+			TargetID:    auditTargetID(c),
+			RequestHash: fingerprint.sum(),
+			Status:      strconv.Itoa(c.Writer.Status()),
+			ReceivedAt:  receivedAt.Format(time.RFC3339),
+		})
+	}
+}
+
+// setAuditTarget names the document a request acts on for routes without an ID parameter, such as
+// creates
+func setAuditTarget(c *gin.Context, id string) {
+	c.Set(apiAuditTargetKey, id)
+}
+
+// auditTargetID is the document named by the handler, or else the route's first parameter
+func auditTargetID(c *gin.Context) string {
+	if id := c.GetString(apiAuditTargetKey); id != "" {
+		return id
+	}
+	if len(c.Params) > 0 {
+		return c.Params[0].Value
+	}
+	return ""
+}
+
+// grpcAPIAuditInterceptor audits the Create, Update and Delete RPCs the same way as REST mutations
+func grpcAPIAuditInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	method := grpcAuditMethod(info.FullMethod)
+	if apiAudits == nil || method == "" {
+		return handler(ctx, req)
+	}
+	receivedAt := time.Now().UTC()
+	resp, err := handler(ctx, req)
+
+	h := sha256.New()
+	io.WriteString(h, method+" "+info.FullMethod+"\x00")
+	if target := targetFromContext(ctx); target != nil {
+		io.WriteString(h, target.Name)
+	}
+	h.Write([]byte{0})
+	if message, ok := req.(proto.Message); ok {
+		data, _ := proto.MarshalOptions{Deterministic: true}.Marshal(message)
+		h.Write(data)
+	}
+
+	var apiKey, authorization, clientIP string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(apiKeyHeader); len(values) > 0 {
+			apiKey = values[0]
+		}
+		if values := md.Get("authorization"); len(values) > 0 {
+			authorization = values[0]
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		clientIP, _, _ = net.SplitHostPort(p.Addr.String())
+	}
+	apiAudits.record(ctx, APIAuditEntry{
+		RequestID:   newRequestID(ctx),
+		Actor:       apiActor(ctx, apiKey, authorization, clientIP),
+		Method:      method,
+		Route:       info.FullMethod,
+		TargetID:    grpcAuditTarget(req),
+		RequestHash: hex.EncodeToString(h.Sum(nil)),
+		Status:      status.Code(err).String(),
+		ReceivedAt:  receivedAt.Format(time.RFC3339),
+	})
+	return resp, err
+}
+
+// grpcAuditMethod maps mutating RPCs to the REST method they correspond to
+func grpcAuditMethod(fullMethod string) string {
+	name := fullMethod[strings.LastIndex(fullMethod, "/")+1:]
+	switch {
+	case strings.HasPrefix(name, "Create"):
+		return "POST"
+	case strings.HasPrefix(name, "Update"):
+		return "PUT"
+	case strings.HasPrefix(name, "Delete"):
+		return "DELETE"
+	}
+	return ""
+}
+
+// grpcAuditTarget takes the document ID from the request; evidence is preferred over the incident
+// it belongs to
+func grpcAuditTarget(req interface{}) string {
+	if r, ok := req.(interface{ GetId() string }); ok {
+		return r.GetId()
+	}
+	if r, ok := req.(interface{ GetEvidenceId() string }); ok {
+		return r.GetEvidenceId()
+	}
+	if r, ok := req.(interface{ GetIncidentId() string }); ok {
+		return r.GetIncidentId()
+	}
+	if r, ok := req.(interface{ GetDigitalId() string }); ok {
+		return r.GetDigitalId()
+	}
+	return ""
+}
+
+// apiActor identifies the caller without storing credentials: the wallet identity a request signs
+// as, otherwise its client certificate's name, a hash of its API key, its bearer token subject or
+// its address. The address is ClientIP's, which believes X-Forwarded-For only from TRUSTED_PROXIES.
+func apiActor(ctx context.Context, apiKey, authorization, clientIP string) string {
+	if id, ok := ctx.Value(signerContextKey{}).(*walletIdentity); ok && id != nil {
+		return "wallet:" + id.Label
+	}
+	if caller := clientCertFromContext(ctx); caller != nil {
+		return "cert:" + caller.Name
+	}
+	if apiKey != "" {
+		sum := sha256.Sum256([]byte(apiKey))
+		return "key:" + hex.EncodeToString(sum[:8])
+	}
+	if sub := bearerSubject(authorization); sub != "" {
+		return "sub:" + sub
+	}
+	return "ip:" + clientIP
+}
+
+// newRequestID joins the request's trace and span IDs, so an audit entry leads to its logs and
+// traces; requests without a span get a random ID
+func newRequestID(ctx context.Context) string {
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		return sc.TraceID().String() + "-" + sc.SpanID().String()
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,124 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	sihv1 "assetTransfer/proto/sih/v1"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAPIAuditTrail(t *testing.T) {
+	apiAudits = newAPIAuditor(2, 0, 4)
+	defer func() { apiAudits = nil }()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(apiAuditMiddleware())
+	r.POST("/api/v1/incident/", func(c *gin.Context) {
+		var req CreateIncidentRequest
+		if !bindRequest(c, &req) {
+			return
+		}
+		setAuditTarget(c, req.IncidentID)
+		c.Status(http.StatusCreated)
+	})
+	r.GET("/api/v1/incident/:id", func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.DELETE("/api/v1/incident/:id", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	send := func(method, path, body string) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set(apiKeyHeader, "district-key")
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	valid := `{"incidentID":"inc-1","incidentSummaryHash":"` + strings.Repeat("a", 64) + `","reporter":"officer","severity":"high"}`
+	send(http.MethodPost, "/api/v1/incident/", valid)
+	send(http.MethodGet, "/api/v1/incident/inc-1", "")
+	send(http.MethodPost, "/api/v1/incident/", `{"incidentID":"inc-2"}`)
+
+	batch := apiAudits.batches[apiAuditBatchKey{}]
+	if batch == nil || len(batch.entries) != 2 {
+		t.Fatalf("expected the two mutations queued and the read skipped, got %+v", apiAudits.batches)
+	}
+	created, rejected := batch.entries[0], batch.entries[1]
+	if created.Method != "POST" || created.Route != "/api/v1/incident/" || created.TargetID != "inc-1" || created.Status != "201" {
+		t.Errorf("unexpected entry for the create %+v", created)
+	}
+	if rejected.Status != "400" || rejected.TargetID != "" || rejected.RequestHash == created.RequestHash || len(created.RequestHash) != 64 {
+		t.Errorf("expected the rejected create recorded with its own request hash, got %+v", rejected)
+	}
+	if created.Actor != rejected.Actor || !strings.HasPrefix(created.Actor, "key:") || strings.Contains(created.Actor, "district-key") {
+		t.Errorf("expected the caller identified by a hash of its API key, got %q", created.Actor)
+	}
+	if created.RequestID == "" || created.RequestID == rejected.RequestID {
+		t.Errorf("expected distinct request IDs, got %q and %q", created.RequestID, rejected.RequestID)
+	}
+	select {
+	case <-apiAudits.full:
+	default:
+		t.Error("expected a full batch to trigger a flush")
+	}
+
+	// Entries of a failed batch go back ahead of newer ones, within API_AUDIT_MAX_PENDING
+	apiAudits.batches, apiAudits.pending = map[apiAuditBatchKey]*apiAuditBatch{}, 0
+	send(http.MethodDelete, "/api/v1/incident/inc-1", "")
+	apiAudits.requeue(apiAuditBatchKey{}, batch, append(batch.entries, APIAuditEntry{RequestID: "retried"}))
+	got := apiAudits.batches[apiAuditBatchKey{}].entries
+	if apiAudits.pending != 4 || apiAudits.dropped != 0 || got[0].TargetID != "inc-1" || got[2].RequestID != "retried" || got[3].Method != "DELETE" {
+		t.Errorf("unexpected queue after requeue: pending %d, dropped %d, %+v", apiAudits.pending, apiAudits.dropped, got)
+	}
+	send(http.MethodDelete, "/api/v1/incident/inc-2", "")
+	apiAudits.requeue(apiAuditBatchKey{}, batch, []APIAuditEntry{{RequestID: "late"}})
+	if apiAudits.pending != 4 || apiAudits.dropped != 2 {
+		t.Errorf("expected entries beyond the queue limit dropped, got pending %d, dropped %d", apiAudits.pending, apiAudits.dropped)
+	}
+
+	wallet := withSigner(context.Background(), &walletIdentity{Label: "shillong", MSPID: "Org2MSP"})
+	if actor := apiActor(wallet, "district-key", "", "10.0.0.1"); actor != "wallet:shillong" {
+		t.Errorf("expected the wallet label, got %q", actor)
+	}
+	if actor := apiActor(context.Background(), "", "", "10.0.0.1"); actor != "ip:10.0.0.1" {
+		t.Errorf("expected the client address, got %q", actor)
+	}
+
+	for method, want := range map[string]string{
+		"/sih.v1.LedgerService/CreateEvidence": "POST",
+		"/sih.v1.LedgerService/UpdateDID":      "PUT",
+		"/sih.v1.LedgerService/DeleteIncident": "DELETE",
+		"/sih.v1.LedgerService/SearchAudits":   "",
+	} {
+		if got := grpcAuditMethod(method); got != want {
+			t.Errorf("%s: expected %q, got %q", method, want, got)
+		}
+	}
+	if target := grpcAuditTarget(&sihv1.CreateEvidenceRequest{EvidenceId: "ev-1", IncidentId: "inc-1"}); target != "ev-1" {
+		t.Errorf("expected the evidence ID, got %q", target)
+	}
+	if target := grpcAuditTarget(&sihv1.DeleteDocumentRequest{Id: "did-1"}); target != "did-1" {
+		t.Errorf("expected the document ID, got %q", target)
+	}
+}
+
+func TestAPIAuditActorIgnoresForwardedFor(t *testing.T) {
+	apiAudits = newAPIAuditor(10, 0, 10)
+	defer func() { apiAudits = nil }()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	setTrustedProxies(r)
+	r.Use(apiAuditMiddleware())
+	r.DELETE("/api/v1/incident/:id", func(c *gin.Context) { c.Status(http.StatusOK) })
+	req := httptest.NewRequest(http.MethodDelete, "/api/v1/incident/inc-1", nil)
+	req.RemoteAddr = "203.0.113.9:40000"
+	req.Header.Set("X-Forwarded-For", "198.51.100.7")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	batch := apiAudits.batches[apiAuditBatchKey{}]
+	if batch == nil || len(batch.entries) != 1 || batch.entries[0].Actor != "ip:203.0.113.9" {
+		t.Fatalf("expected the anonymous caller audited by its connection's address, got %+v", apiAudits.batches)
+	}
+}
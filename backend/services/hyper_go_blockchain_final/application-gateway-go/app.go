@@ -4,12 +4,14 @@ Copyright 2022 IBM All Rights Reserved.
 SPDX-License-Identifier: Apache-2.0
 */
 
-package main
+package gateway
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -18,16 +20,19 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/hyperledger/fabric-gateway/pkg/client"
 	"github.com/hyperledger/fabric-gateway/pkg/hash"
+	"github.com/hyperledger/fabric-gateway/pkg/identity"
+	"google.golang.org/grpc"
 )
 
+// The channel and chaincode served when FABRIC_TARGETS is not set
 const (
-	channelName   = "mychannel"
-	chaincodeName = "sihcc"
+	defaultChannelName   = "mychannel"
+	defaultChaincodeName = "sihcc"
 )
 
 var (
-	contract *client.Contract
-	network  *client.Network
+	gateway          *client.Gateway
+	clientConnection *grpc.ClientConn
 )
 
 // DIDDocument represents a Digital ID document
@@ -41,6 +46,26 @@ type DIDDocument struct {
 	TxID        string `json:"tx_id"`
 }
 
+// DIDVerification is the verdict on whether a DID can be trusted at a checkpoint
+type DIDVerification struct {
+	DigitalID  string `json:"digital_id"`
+	Valid      bool   `json:"valid"`
+	Exists     bool   `json:"exists"`
+	Expired    bool   `json:"expired"`
+	Revoked    bool   `json:"revoked"`
+	Reason     string `json:"reason,omitempty"`
+	Issuer     string `json:"issuer,omitempty"`
+	IssuedAt   string `json:"issued_at,omitempty"`
+	ExpiresAt  string `json:"expires_at,omitempty"`
+	VerifiedAt string `json:"verified_at"`
+	TxID       string `json:"tx_id,omitempty"`
+	// Source is "ledger", or "cache" when the DID validity cache answered. A cached state was
+	// read or last changed at CachedAt and is at most MaxStalenessSeconds old.
+	Source              string `json:"source,omitempty"`
+	CachedAt            string `json:"cached_at,omitempty"`
+	MaxStalenessSeconds int    `json:"max_staleness_seconds,omitempty"`
+}
+
 // IncidentDocument represents an incident record
 type IncidentDocument struct {
 	DocType             string `json:"doc_type"`
@@ -48,18 +73,39 @@ type IncidentDocument struct {
 	IncidentSummaryHash string `json:"incident_summary_hash"`
 	CreatedAt           string `json:"created_at"`
 	Reporter            string `json:"reporter"`
+	Status              string `json:"status,omitempty"`
+	Severity            string `json:"severity,omitempty"`
+	Category            string `json:"category,omitempty"`
+	SubjectID           string `json:"subject_id,omitempty"`
+	Geohash             string `json:"geohash,omitempty"`
+	AcknowledgedAt      string `json:"acknowledged_at,omitempty"`
+	ResolvedAt          string `json:"resolved_at,omitempty"`
+	MergedInto          string `json:"merged_into,omitempty"`
+	OwnerOrg            string `json:"owner_org,omitempty"`
 	TxID                string `json:"tx_id"`
 }
 
+// IncidentPage is one page of incident search results
+type IncidentPage struct {
+	Incidents []IncidentDocument `json:"incidents"`
+	Bookmark  string             `json:"bookmark,omitempty"`
+	Count     int                `json:"count"`
+}
+
 // EvidenceDocument represents evidence anchored to an incident
 type EvidenceDocument struct {
 	DocType      string `json:"doc_type"`
+	EvidenceID   string `json:"evidence_id,omitempty"`
 	EvidenceHash string `json:"evidence_hash"`
 	IncidentID   string `json:"incident_id"`
 	MediaType    string `json:"media_type"`
 	UploadedBy   string `json:"uploaded_by"`
 	CreatedAt    string `json:"created_at"`
-	TxID         string `json:"tx_id"`
+	CID          string `json:"cid,omitempty"`
+	// EncryptionKeyRef names the data key the stored file is encrypted with
+	EncryptionKeyRef string `json:"encryption_key_ref,omitempty"`
+	OwnerOrg         string `json:"owner_org,omitempty"`
+	TxID             string `json:"tx_id"`
 }
 
 // AuditDocument represents an audit log entry
@@ -71,6 +117,18 @@ type AuditDocument struct {
 	TargetID  string `json:"target_id"`
 	Timestamp string `json:"timestamp"`
 	TxID      string `json:"tx_id"`
+	Submitter string `json:"submitter,omitempty"`
+	// Route, RequestID and Status describe the API request behind an API_* entry
+	Route     string `json:"route,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+	Status    string `json:"status,omitempty"`
+}
+
+// AuditPage is one page of audit search results
+type AuditPage struct {
+	Audits   []AuditDocument `json:"audits"`
+	Bookmark string          `json:"bookmark,omitempty"`
+	Count    int             `json:"count"`
 }
 
 // Request structs for API
@@ -95,6 +153,23 @@ type CreateIncidentRequest struct {
 	IncidentID          string `json:"incidentID" binding:"required"`
 	IncidentSummaryHash string `json:"incidentSummaryHash" binding:"required"`
 	Reporter            string `json:"reporter" binding:"required"`
+	Severity            string `json:"severity"`
+	Category            string `json:"category"`
+	// DigitalID is the tourist the incident concerns, such as the missing person
+	DigitalID string `json:"digitalID"`
+	// Latitude and Longitude place the incident on heatmaps; only a coarse geohash is recorded
+	Latitude  *float64 `json:"latitude"`
+	Longitude *float64 `json:"longitude"`
+}
+
+type IncidentSearchRequest struct {
+	From     string `form:"from"`
+	To       string `form:"to"`
+	Status   string `form:"status"`
+	Severity string `form:"severity"`
+	Reporter string `form:"reporter"`
+	PageSize int    `form:"page_size"`
+	Bookmark string `form:"bookmark"`
 }
 
 type UpdateIncidentRequest struct {
@@ -102,6 +177,27 @@ type UpdateIncidentRequest struct {
 	Updater             string `json:"updater" binding:"required"`
 }
 
+type UpdateIncidentStatusRequest struct {
+	Status  string `json:"status" binding:"required"`
+	Updater string `json:"updater" binding:"required"`
+}
+
+// MergeIncidentsRequest closes duplicate reports of an incident, pointing each at it
+type MergeIncidentsRequest struct {
+	DuplicateIDs []string `json:"duplicateIDs" binding:"required"`
+	Actor        string   `json:"actor" binding:"required"`
+}
+
+// EvidenceListRequest filters and orders an incident's evidence. Sort is created_at, media_type or
+// uploaded_by, descending with a leading "-".
+type EvidenceListRequest struct {
+	MediaType  string `form:"media_type"`
+	UploadedBy string `form:"uploader"`
+	From       string `form:"from"`
+	To         string `form:"to"`
+	Sort       string `form:"sort"`
+}
+
 type CreateEvidenceRequest struct {
 	EvidenceID   string `json:"evidenceID" binding:"required"`
 	EvidenceHash string `json:"evidenceHash" binding:"required"`
@@ -110,97 +206,397 @@ type CreateEvidenceRequest struct {
 	UploadedBy   string `json:"uploadedBy" binding:"required"`
 }
 
+type AuditSearchRequest struct {
+	Actor    string `form:"actor"`
+	Action   string `form:"action"`
+	From     string `form:"from"`
+	To       string `form:"to"`
+	PageSize int    `form:"page_size"`
+	Bookmark string `form:"bookmark"`
+}
+
+type IncidentExportRequest struct {
+	From     string `form:"from" binding:"required"`
+	To       string `form:"to" binding:"required"`
+	Status   string `form:"status"`
+	Severity string `form:"severity"`
+	Reporter string `form:"reporter"`
+	Format   string `form:"format"`
+}
+
+// DocumentExportRequest projects exported documents onto the Include fields, or drops the Exclude
+// fields; both are comma-separated JSON field names
+type DocumentExportRequest struct {
+	Include string `form:"include"`
+	Exclude string `form:"exclude"`
+}
+
+type AuditExportRequest struct {
+	Actor  string `form:"actor"`
+	Action string `form:"action"`
+	From   string `form:"from" binding:"required"`
+	To     string `form:"to" binding:"required"`
+	Format string `form:"format"`
+}
+
 type UpdateEvidenceRequest struct {
 	EvidenceHash string `json:"evidenceHash" binding:"required"`
 	MediaType    string `json:"mediaType" binding:"required"`
 	Updater      string `json:"updater" binding:"required"`
 }
 
-func main() {
+// RunGateway is the entry point of the sih-app binary
+func RunGateway() {
+	initI18n()
+
 	// Initialize Fabric Gateway connection
+	initCircuitBreaker()
 	initFabricConnection()
 	defer closeFabricConnection()
+	initNetworkMonitor()
+	initWallet()
+	initTenancy()
+	initAccessControl()
+	initAPIAudit()
+	initEvidenceStore()
+	initEvidenceUploads()
+	initMediaPipeline()
+	initCCTV()
+	initEvidenceEncryption()
+	initQRSigner()
+	initQRRevocations()
+	initDIDBundle()
+	initBulkIssuance()
+	initEFIRTemplate()
+	initSOS()
+	initStats()
+	initOffchainIndex()
+	initDIDValidityCache()
+	initDocumentCache()
+	initEventLeadership()
+	initCanary()
+	initSMS()
+	initEmail()
+	initPush()
+	initRequestSigning()
+	initSessions()
+	initProvisioning()
+	initEnrollment()
+	initGeofence()
+	initZoneRisk()
+	initSafeRoutes()
+	initFusion()
+	initLocation()
+	initAnalytics()
+	initDevices()
+	initWearables()
+	initStays()
+	initPermits()
+	initClaims()
+	initOperators()
+	initMissingPersons()
+	initModelDecisions()
+	initAnomalies()
+	initWeather()
+	initAdvisories()
+	initBroadcasts()
+	initCrowds()
+	initSafety()
+	initDispatch()
+	initUnitTracking()
+	initSOSConfirmation()
+	initDedup()
+	initOrgGrants()
+	initCompliance()
+	initOrchestrator()
+	initWorkflows()
+	initSLA()
+	initArchive()
+	initParquetExport()
+	initKYC()
+	initContacts()
+	initChatbot()
+	initHelpline()
+	initConsent()
+	initConsentReceipts()
+	initErasure()
+	initShares()
+	initWebAuthn()
+	initCredentials()
+	initERSS()
+	initDIDResolver()
+	initReports()
+	initSync()
+	initChanges()
+	initScheduler()
 
 	// Start chaincode event listening
 	ctx, cancel := context.WithCancel(context.Background())
+	defer shutdownTracing()
 	defer cancel()
-	go startChaincodeEventListening(ctx, network)
+	initTracing(ctx)
+	for _, target := range sortedTargets() {
+		go startChaincodeEventListening(ctx, target)
+		go changes.run(ctx, target)
+	}
+	go startWatchdog(ctx)
+	if peerDiscoverer != nil {
+		go peerDiscoverer.run(ctx)
+	}
+	if peerRouting != nil {
+		go peerRouting.run(ctx)
+	}
+	if eventLeadership != nil {
+		go eventLeadership.run(ctx)
+	}
+	go fabricMonitor.run(ctx)
+	if canary != nil {
+		go canary.run(ctx)
+	}
+	if access != nil {
+		go access.watchSIGHUP(ctx)
+	}
+	go geofence.run(ctx)
+	go orgGrants.run(ctx)
+	if zoneRisk != nil {
+		go zoneRisk.run(ctx)
+	}
+	go locations.run(ctx)
+	go devices.run(ctx)
+	if wearables != nil {
+		go wearables.run(ctx)
+	}
+	if anomalies != nil {
+		go anomalies.run(ctx)
+	}
+	go advisories.run(ctx)
+	go safety.run(ctx)
+	if decisions != nil {
+		go decisions.run(ctx)
+	}
+	if dispatcher != nil {
+		go dispatcher.run(ctx)
+	}
+	if unitFixes != nil {
+		go unitFixes.run(ctx)
+	}
+	if sosConfirm != nil {
+		go sosConfirm.run(ctx)
+	}
+	if orchestrator != nil {
+		go orchestrator.run(ctx)
+	}
+	go slas.run(ctx)
+	go scheduler.run(ctx)
+	if apiAudits != nil {
+		go apiAudits.run(ctx)
+	}
+	if offchain != nil && !offchain.external {
+		go offchain.run(ctx, defaultTarget)
+	}
+	if offchain != nil {
+		go liveBoard.run(ctx, offchain, getEnvDuration("DASHBOARD_RESYNC_INTERVAL", defaultDashboardResync))
+	}
+	if offchain != nil && didValidities != nil {
+		go didValidities.run(ctx, offchain)
+	}
+	if emailer != nil {
+		go emailer.run(ctx)
+	}
+	if erss != nil && erss.endpoint != "" {
+		go erss.run(ctx)
+	}
+	if media != nil {
+		go media.run(ctx)
+	}
+	if store, ok := evidenceStore.(*ipfsStore); ok {
+		go startIPFSVerifier(ctx, store)
+	}
 
 	// Setup Gin router
-	r := setupRouter()
+	r := setupRouter(ctx)
 
-	// Start server
-	log.Println("🚀 SIH Chaincode API Server starting on :8080")
-	log.Fatal(r.Run(":8080"))
+	// Start servers
+	reloader, err := loadTLSReloader(ctx)
+	if err != nil {
+		log.Fatalf("Failed to load TLS certificate: %v", err)
+	}
+	if grpcEnabled() {
+		go func() {
+			if err := runGRPCServer(ctx, reloader); err != nil {
+				log.Printf("gRPC server stopped: %v", err)
+			}
+		}()
+	}
+	log.Fatal(runServer(r, reloader))
 }
 
 func initFabricConnection() {
-	clientConnection := newGrpcConnection()
+	clientConnection = newGrpcConnection()
 
-	id := newIdentity()
-	sign := newSign()
-
-	gateway, err := client.Connect(
-		id,
-		client.WithSign(sign),
-		client.WithHash(hash.SHA256),
-		client.WithClientConnection(clientConnection),
-		client.WithEvaluateTimeout(5*time.Second),
-		client.WithEndorseTimeout(15*time.Second),
-		client.WithSubmitTimeout(5*time.Second),
-		client.WithCommitStatusTimeout(1*time.Minute),
-	)
+	id, sign := newIdentity(), newSign()
+	var err error
+	gateway, err = connectGateway(id, sign)
 	if err != nil {
 		panic(fmt.Errorf("failed to connect to gateway: %w", err))
 	}
 
-	network = gateway.GetNetwork(channelName)
-	contract = network.GetContract(chaincodeName)
+	initFabricTargets()
+	if peerDiscoverer != nil {
+		peerDiscoverer.start(id, sign)
+	}
 
 	log.Println("✅ Connected to Hyperledger Fabric network")
 }
 
+// connectGateway opens a gateway for one signing identity over the shared gRPC connection
+func connectGateway(id identity.Identity, sign identity.Sign) (*client.Gateway, error) {
+	return client.Connect(
+		id,
+		client.WithSign(sign),
+		client.WithHash(hash.SHA256),
+		client.WithClientConnection(clientConnection),
+		client.WithEvaluateTimeout(evaluateTimeout),
+		client.WithEndorseTimeout(endorseTimeout),
+		client.WithSubmitTimeout(submitTimeout),
+		client.WithCommitStatusTimeout(commitStatusTimeout),
+	)
+}
+
 func closeFabricConnection() {
 	log.Println("🔌 Closing Fabric connection...")
+	if callerWallet != nil {
+		callerWallet.close()
+	}
+	if gateway != nil {
+		gateway.Close()
+	}
+	closeHSMSigners()
+	if clientConnection != nil {
+		clientConnection.Close()
+	}
 }
 
-func setupRouter() *gin.Engine {
+// setTrustedProxies limits the peers whose X-Forwarded-For ClientIP believes to TRUSTED_PROXIES. By
+// default there are none, so the IP that rate limits and audit entries key on is the connection's
+// own, and a caller cannot choose it.
+func setTrustedProxies(r *gin.Engine) {
+	if err := r.SetTrustedProxies(splitList(getEnv("TRUSTED_PROXIES", ""))); err != nil {
+		panic(fmt.Errorf("TRUSTED_PROXIES must list IP addresses or CIDR ranges: %w", err))
+	}
+}
+
+func setupRouter(ctx context.Context) *gin.Engine {
 	gin.SetMode(gin.ReleaseMode)
-	r := gin.Default()
+	r := gin.New()
+	setTrustedProxies(r)
+	r.Use(gin.Recovery(), tracingMiddleware(), traceResponseMiddleware(), requestLogger())
 
 	// CORS middleware
-	r.Use(func(c *gin.Context) {
-		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
-
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(204)
-			return
-		}
-
-		c.Next()
-	})
+	r.Use(corsMiddleware(loadCORSConfig()))
+	if getEnvBool("COMPRESSION_ENABLED", true) {
+		r.Use(compressionMiddleware(getEnvInt("COMPRESSION_MIN_BYTES", 1024), getEnvInt("COMPRESSION_LEVEL", gzip.DefaultCompression)))
+	}
 
 	// Health check endpoint
-	r.GET("/health", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{
-			"status":    "OK",
-			"message":   "SIH Chaincode API is running",
-			"timestamp": time.Now().Format(time.RFC3339),
-			"version":   "1.0.0",
-		})
-	})
+	r.GET("/health", healthCheck)
+
+	// Kubernetes probes
+	r.GET("/livez", livenessCheck)
+	r.GET("/readyz", readinessCheck)
+
+	// Prometheus metrics for the Fabric network
+	r.GET("/metrics", metricsHandler(getEnv("METRICS_TOKEN", "")))
+
+	// API documentation
+	r.GET("/openapi.json", openAPIHandler)
+	r.GET("/docs", swaggerUIHandler)
+
+	// SMS delivery reports, authenticated by the provider rather than an API key
+	r.POST("/callbacks/sms", smsCallback)
+	// Accommodation provider check-ins and check-outs, authenticated by the provider's signature
+	r.POST("/callbacks/accommodation/:providerId", stayWebhook)
+	// 112 ERSS events, authenticated by ERSS's signature
+	r.POST("/callbacks/erss", erssWebhook)
+	// Chat assistant fulfillment, authenticated by the webhook token
+	r.POST("/callbacks/chatbot/dialogflow", dialogflowWebhook)
+	r.POST("/callbacks/chatbot/rasa", rasaWebhook)
+	// Helpline calls, authenticated by Twilio's signature or Exotel's token. Twilio posts, Exotel gets.
+	r.GET("/callbacks/voice/incoming", helplineIncoming)
+	r.POST("/callbacks/voice/incoming", helplineIncoming)
+	r.GET(helplineStatusPath, helplineStatus)
+	r.POST(helplineStatusPath, helplineStatus)
+	// Linked from incident update emails, authenticated by a signed token
+	r.GET("/notifications/unsubscribe", unsubscribeEmail)
+
+	limit := rateLimitMiddleware(ctx)
+
+	// Family tracking links, authenticated by the token in the link
+	r.GET(shareViewRoute, limit, viewShare)
+
+	// Verifiable credential checks for third parties, who hold no API key
+	r.POST("/credentials/verify", limit, verifyCredential)
+	r.GET("/credentials/status/:list", limit, getStatusList)
+	r.GET("/credentials/jwks", limit, getCredentialKeys)
+
+	// Erasure certificate checks for regulators and tourists, who hold no API key
+	r.POST("/erasures/verify", limit, verifyErasureCertificate)
+	r.GET("/erasures/jwks", limit, getErasureKeys)
+
+	// Compliance report signature checks for auditors, who hold no API key
+	r.GET("/compliance/jwks", limit, getComplianceKeys)
+
+	// DID QR keys and revocation list for police devices verifying without a network
+	r.GET("/did/jwks", limit, getDIDQRKeys)
+	r.GET("/did/revocations", limit, getDIDRevocationList)
+
+	// DID Resolution HTTP(S) binding, for standard wallet and verifier tooling
+	r.GET("/1.0/identifiers/:did", limit, resolveIdentifier)
+
+	// Public verification portal for anyone holding a FIR copy or an evidence hash
+	if getEnvBool("PUBLIC_VERIFY_ENABLED", true) {
+		public := r.Group("/public/verify", publicVerifyMiddleware(ctx))
+		public.GET("/fir", publicVerifyFIR)
+		public.GET("/hash/:hash", publicVerifyHash)
+	}
+
+	// GraphQL read models
+	route, signer, split, authorize := targetMiddleware(), signerMiddleware(), canaryMiddleware(), authorizeMiddleware()
+	r.GET("/graphql", limit, route, signer, split, authorize, graphqlHandler)
+	r.POST("/graphql", limit, route, signer, split, authorize, graphqlHandler)
+
+	// Session tokens. Callers manage their own sessions without a role, and a refresh token is its
+	// own credential, so refreshing skips the signer.
+	r.POST("/api/v1/auth/refresh", limit, apiAuditMiddleware(), refreshSession)
+	auth := r.Group("/api/v1/auth", limit, signer, apiAuditMiddleware())
+	{
+		auth.POST("/token", issueToken)
+		auth.POST("/logout", logout)
+		auth.POST("/revoke-all", revokeAllSessions)
+	}
 
 	// API routes
-	api := r.Group("/api/v1")
+	api := r.Group("/api/v1", limit, route, signer, split, apiAuditMiddleware(), authorize, signatureMiddleware(), idempotencyMiddleware())
 	{
+		// Channel and chaincode registry
+		api.GET("/targets", listTargets)
+		api.GET("/canary", getCanary)
+		api.PUT("/canary", updateCanary)
+		api.POST("/canary/rollback", rollbackCanary)
+		api.GET("/network/peers", getNetworkTopology)
+		api.GET("/network/health", getNetworkHealth)
+
 		// DID routes
 		did := api.Group("/did")
 		{
 			did.POST("/", createDID)
+			did.POST("/bulk", bulkCreateDIDs)
 			did.GET("/:id", getDID)
+			did.GET("/:id/verify", verifyDID)
+			did.GET("/:id/qr", getDIDQR)
+			did.POST("/verify-qr", verifyDIDQR)
+			did.POST("/verify-qr/offline", verifyDIDQROffline)
+			did.GET("/bundle", getDIDBundle)
 			did.PUT("/:id", updateDID)
 			did.DELETE("/:id", deleteDID)
 		}
@@ -209,26 +605,417 @@ func setupRouter() *gin.Engine {
 		incident := api.Group("/incident")
 		{
 			incident.POST("/", createIncident)
+			incident.GET("", searchIncidents)
+			incident.GET("/export", exportIncidents)
 			incident.GET("/:id", getIncident)
 			incident.PUT("/:id", updateIncident)
+			incident.PUT("/:id/status", updateIncidentStatus)
+			incident.POST("/:id/merge", mergeIncidents)
+			incident.GET("/:id/changes", getIncidentChanges)
+			incident.GET("/:id/calls", getIncidentCalls)
+			incident.GET("/:id/cctv", getIncidentCCTVManifests)
 			incident.DELETE("/:id", deleteIncident)
+			incident.POST("/:id/efir", generateEFIR)
+			incident.GET("/:id/timeline", getIncidentTimeline)
+			incident.POST("/:id/timeline/annexure", generateTimelineAnnexure)
+			incident.GET("/:id/timeline/annexures", listTimelineAnnexures)
+			incident.GET("/:id/archives", listIncidentArchives)
+			incident.GET("/:id/archives/:archiveId", downloadIncidentArchive)
+		}
+
+		// e-FIR records and the FIR number registers
+		api.GET("/efir/register", getFIRRegister)
+		api.GET("/efir/:firId", getEFIR)
+
+		// SOS alerts
+		api.POST("/sos", raiseSOS)
+		api.POST("/sos/:id/confirm", confirmSOS)
+		api.POST("/sos/:id/cancel", cancelSOS)
+		api.GET("/sos/:id/trigger", getSOSTrigger)
+		api.GET("/sms", listSMSMessages)
+
+		// Geofence zones
+		zones := api.Group("/geofence")
+		{
+			zones.GET("/zones", listZones)
+			zones.POST("/zones", createZone)
+			zones.POST("/zones/import", importZones)
+			zones.GET("/zones/export", exportZones)
+			zones.GET("/zones/:id", getZone)
+			zones.PUT("/zones/:id", updateZone)
+			zones.DELETE("/zones/:id", deleteZone)
+			zones.POST("/evaluate", evaluateGeofence)
+			zones.GET("/risk", listZoneRisk)
+			zones.GET("/risk/:id", getZoneRisk)
+			zones.POST("/risk/recompute", recomputeZoneRisk)
+			zones.GET("/routes", getSafeRoutes)
+			zones.POST("/crowd/estimates", ingestCrowdEstimates)
+			zones.GET("/crowd", listCrowdZones)
+			zones.GET("/crowd/:id", getCrowdZone)
+			zones.GET("/rules", listAlertRules)
+			zones.GET("/rules/:id", getAlertRule)
+			zones.PUT("/rules/:id", putAlertRule)
+			zones.DELETE("/rules/:id", deleteAlertRule)
+			zones.GET("/groups", listTouristGroups)
+			zones.GET("/groups/:id", getTouristGroup)
+			zones.PUT("/groups/:id", putTouristGroup)
+			zones.DELETE("/groups/:id", deleteTouristGroup)
+		}
+
+		// Tourist location pings
+		location := api.Group("/location")
+		{
+			location.POST("/pings", ingestLocationPings)
+			location.POST("/estimate", estimateLocation)
+			location.GET("/:digitalId", getLiveLocation)
+			location.GET("/:digitalId/anchors", getLocationAnchors)
+		}
+
+		// Hotel and homestay stays reported by accommodation providers
+		stays := api.Group("/stays")
+		{
+			stays.POST("/check-in", checkInStay)
+			stays.POST("/check-out", checkOutStay)
+			stays.GET("/:digitalId", listStays)
+		}
+
+		// Restricted and trekking zone permits, and the checkpoint check of them
+		zonePermits := api.Group("/permits")
+		{
+			zonePermits.POST("/applications", submitPermitApplication)
+			zonePermits.GET("/applications/:id", getPermitApplication)
+			zonePermits.POST("/applications/:id/approve", reviewPermitApplication(true))
+			zonePermits.POST("/applications/:id/reject", reviewPermitApplication(false))
+			zonePermits.GET("/review", listPermitReviewQueue)
+			zonePermits.POST("/verify", verifyPermit)
+			zonePermits.GET("/tourist/:digitalId", listPermits)
+			zonePermits.GET("/:id", getPermit)
+			zonePermits.POST("/:id/revoke", revokePermit)
+		}
+
+		// Missing person cases, opened when a missing person incident is filed
+		missing := api.Group("/missing-persons")
+		{
+			missing.POST("", openMissingPersonCase)
+			missing.GET("/:incidentId", getMissingPersonCase)
+			missing.POST("/:incidentId/stages", advanceMissingPersonCase)
+			missing.GET("/:incidentId/anchors", listCaseAnchors)
+		}
+
+		// Declared itineraries for anomaly detection
+		api.PUT("/itinerary/:digitalId", putItinerary)
+		api.GET("/itinerary/:digitalId", getItinerary)
+		api.GET("/itinerary/:digitalId/deviation", getItineraryDeviation)
+		api.GET("/itinerary/:digitalId/anchors", listItineraryAnchors)
+		api.DELETE("/itinerary/:digitalId", deleteItinerary)
+
+		// Emergency contacts tourists register, notified once verified
+		api.POST("/contacts/:digitalId", createContact)
+		api.GET("/contacts/:digitalId", listContacts)
+		api.PUT("/contacts/:digitalId/:contactId", updateContact)
+		api.DELETE("/contacts/:digitalId/:contactId", deleteContact)
+		api.POST("/contacts/:digitalId/:contactId/code", sendContactCode)
+		api.POST("/contacts/:digitalId/:contactId/verify", verifyContact)
+
+		// Safety scores and the weather alerts behind them
+		api.GET("/safety/:digitalId", getSafetyScore)
+		api.GET("/safety/:digitalId/anchors", getSafetyAnchors)
+
+		// Provenance of automated decisions: the model, its version and a hash of its inputs
+		api.GET("/decisions/:alertId", getDecisionProvenance)
+		api.POST("/decisions/:alertId/verify", verifyDecisionFeatures)
+		api.GET("/decisions/tourist/:digitalId", listModelDecisions)
+		api.GET("/weather/alerts", listWeatherAlerts)
+		api.PUT("/weather/alerts/:id", putWeatherAlert)
+		api.DELETE("/weather/alerts/:id", deleteWeatherAlert)
+		api.POST("/weather/advisories", ingestAdvisory)
+		api.GET("/weather/advisories/:id/anchors", listAdvisoryAnchors)
+
+		// Proximity broadcasts to the tourists near an incident or advisory
+		api.POST("/broadcasts", createBroadcast)
+		api.GET("/broadcasts", listBroadcasts)
+		api.GET("/broadcasts/:id", getBroadcast)
+		api.GET("/broadcasts/opt-outs/:digitalId", getBroadcastOptOut)
+		api.PUT("/broadcasts/opt-outs/:digitalId", broadcastOptOutHandler(true))
+		api.DELETE("/broadcasts/opt-outs/:digitalId", broadcastOptOutHandler(false))
+
+		// Responder dispatch
+		api.GET("/dispatch/assignments", listAssignments)
+		api.POST("/dispatch/assignments/:id/acknowledge", acknowledgeAssignment)
+		api.POST("/dispatch/assignments/:id/decline", declineAssignment)
+		api.GET("/dispatch/units", listDispatchUnits)
+		api.GET("/dispatch/units/nearest", listNearestUnits)
+		api.PUT("/dispatch/units/:id/status", setUnitStatus)
+		api.PUT("/dispatch/units/:id/roster", setUnitRoster)
+		api.PUT("/dispatch/units/:id/position", reportUnitPosition)
+		api.POST("/dispatch/units/:id/positions", recordUnitFixes)
+		api.GET("/dispatch/units/:id/track", getUnitTrack)
+
+		// Right to erasure
+		api.POST("/erasures", requestErasure)
+		api.GET("/erasures", listErasures)
+		api.GET("/erasures/:id/certificate", getErasureCertificate)
+
+		// Scheduled operations reports
+		api.POST("/reports", generateReport)
+		api.GET("/reports", listReports)
+		api.GET("/reports/:id/:format", downloadReport)
+
+		// Recurring maintenance jobs
+		api.GET("/jobs", listJobs)
+		api.GET("/jobs/:name", getJob)
+		api.POST("/jobs/:name/run", runJob)
+
+		// Incident SLA compliance and breaches
+		api.GET("/sla/compliance", getSLACompliance)
+		api.GET("/sla/breaches", listSLABreaches)
+
+		// Safety band registry and telemetry
+		bands := api.Group("/wearables")
+		{
+			bands.POST("", registerWearable)
+			bands.GET("/:deviceId", getWearable)
+			bands.POST("/:deviceId/bind", bindWearable)
+			bands.POST("/:deviceId/unbind", unbindWearable)
+			bands.GET("/:deviceId/bindings", listWearableBindings)
+			bands.GET("/:deviceId/telemetry", getWearableTelemetry)
+		}
+
+		// Helpline calls
+		api.GET("/helpline/calls/:id", getHelplineCall)
+
+		// Push notification devices
+		api.POST("/push/devices", registerPushDevice)
+		api.DELETE("/push/devices", unregisterPushDevice)
+
+		// Incident update email preferences
+		api.GET("/notifications/email/:userId", getEmailPreference)
+		api.PUT("/notifications/email/:userId", updateEmailPreference)
+		api.GET("/notifications/escalations/:id", getEscalation)
+		api.POST("/notifications/escalations/:id/acknowledge", acknowledgeEscalation)
+
+		// Event-driven workflow rules and the dashboard tickets they open
+		wf := api.Group("/workflows")
+		{
+			wf.GET("/rules", listWorkflowRules)
+			wf.GET("/rules/:id", getWorkflowRule)
+			wf.PUT("/rules/:id", putWorkflowRule)
+			wf.DELETE("/rules/:id", deleteWorkflowRule)
+			wf.GET("/rules/:id/versions", listWorkflowRuleVersions)
+			wf.POST("/rules/:id/rollback", rollbackWorkflowRule)
+			wf.POST("/dry-run", dryRunWorkflow)
+			wf.GET("/tickets", listWorkflowTickets)
+			wf.GET("/tickets/:id", getWorkflowTicket)
+			wf.POST("/tickets/:id/acknowledge", acknowledgeWorkflowTicket)
 		}
 
 		// Evidence routes
 		evidence := api.Group("/evidence")
 		{
 			evidence.POST("/", createEvidence)
+			evidence.POST("/upload", uploadEvidence)
 			evidence.GET("/:id", getEvidence)
+			evidence.GET("/:id/download", downloadEvidence)
+			evidence.GET("/:id/derivatives", listEvidenceDerivatives)
+			evidence.POST("/:id/derivatives", processEvidenceMedia)
+			evidence.GET("/:id/derivatives/:kind", downloadEvidenceDerivative)
 			evidence.PUT("/:id", updateEvidence)
 			evidence.DELETE("/:id", deleteEvidence)
 			evidence.GET("/incident/:incidentId", getEvidenceByIncident)
 		}
 
+		// Insurance claims referencing anchored incidents and evidence
+		claimRefs := api.Group("/claims")
+		{
+			claimRefs.POST("", registerClaimReference)
+			claimRefs.GET("/:id", getClaimReference)
+			claimRefs.GET("/:id/verify", verifyClaimReference)
+			claimRefs.GET("/incident/:incidentId", listClaimReferences)
+		}
+
+		// Lost and found registry for help centers
+		lostFound := api.Group("/lost-found")
+		{
+			lostFound.POST("/items", reportLostFoundItem)
+			lostFound.GET("/items", searchLostFoundItems)
+			lostFound.GET("/items/:id", getLostFoundItem)
+			lostFound.POST("/items/:id/photos", uploadLostFoundPhoto)
+			lostFound.GET("/items/:id/photos/:hash", downloadLostFoundPhoto)
+			lostFound.GET("/items/:id/candidates", listLostFoundCandidates)
+			lostFound.GET("/items/:id/matches", listLostFoundMatches)
+			lostFound.POST("/items/:id/close", closeLostFoundItem)
+			lostFound.POST("/matches", resolveLostFoundMatch)
+			lostFound.GET("/matches/:id", getLostFoundMatch)
+		}
+
+		// Tour operator portal
+		tourGroups := api.Group("/operators/groups")
+		{
+			tourGroups.GET("", listOperatorGroups)
+			tourGroups.GET("/:id", getOperatorGroup)
+			tourGroups.PUT("/:id", putOperatorGroup)
+			tourGroups.DELETE("/:id", deleteOperatorGroup)
+			tourGroups.POST("/:id/dids", issueOperatorGroupDIDs)
+			tourGroups.GET("/:id/status", getOperatorGroupStatus)
+			tourGroups.GET("/:id/alerts", listOperatorGroupAlerts)
+		}
+
+		// CCTV export manifests from municipal systems
+		cctvManifests := api.Group("/cctv/manifests")
+		{
+			cctvManifests.POST("", anchorCCTVManifest)
+			cctvManifests.GET("/:id", getCCTVManifest)
+			cctvManifests.POST("/:id/clips/:clipId/verify", verifyCCTVClip)
+		}
+
+		// Block explorer
+		explorer := api.Group("/ledger")
+		{
+			explorer.GET("/blocks/:number", getBlock)
+			explorer.GET("/tx/:txid", getLedgerTransaction)
+		}
+		api.GET("/tx/:txid/status", getTransactionStatus)
+
+		// Offline signing
+		offline := api.Group("/offline")
+		{
+			offline.POST("/proposals", prepareOfflineProposal)
+			offline.POST("/endorse", endorseOfflineProposal)
+			offline.POST("/submit", submitOfflineTransaction)
+			offline.POST("/status", offlineCommitStatus)
+		}
+
+		// Offline sync
+		api.POST("/sync", syncOperations)
+		api.GET("/sync", deltaSync)
+
+		// Dashboard statistics
+		api.GET("/stats", getStats)
+		api.GET("/heatmap", getHeatmap)
+		api.GET("/analytics/zones", getZoneAnalytics)
+		api.GET("/analytics/zones/export", exportZoneAnalytics)
+
+		// KYC onboarding
+		kyc := api.Group("/kyc")
+		{
+			kyc.POST("/applications", submitKYCApplication)
+			kyc.GET("/applications/:id", getKYCApplication)
+			kyc.GET("/applications/:id/artifacts/:name", getKYCArtifact)
+			kyc.POST("/applications/:id/approve", reviewKYCApplication(true))
+			kyc.POST("/applications/:id/reject", reviewKYCApplication(false))
+			kyc.GET("/review", listKYCReviewQueue)
+		}
+
+		// Family tracking shares
+		shareLinks := api.Group("/shares")
+		{
+			shareLinks.POST("", createShare)
+			shareLinks.GET("", listShares)
+			shareLinks.POST("/:id/revoke", revokeShare)
+		}
+
+		// Read access granted between organizations
+		grants := api.Group("/grants")
+		{
+			grants.POST("", createOrgGrant)
+			grants.GET("", listOrgGrants)
+			grants.GET("/:id", getOrgGrant)
+			grants.POST("/:id/revoke", revokeOrgGrant)
+		}
+
+		// Verifiable credentials bound to DIDs
+		vcs := api.Group("/credentials")
+		{
+			vcs.POST("", issueCredential)
+			vcs.GET("", listCredentials)
+			vcs.POST("/:id/revoke", revokeCredential)
+		}
+
+		// Passkeys bound to DIDs, asserted for sensitive tourist actions
+		passkeys := api.Group("/webauthn/:digitalId")
+		{
+			passkeys.POST("/registration/options", getPasskeyRegistrationOptions)
+			passkeys.POST("/registration", registerPasskey)
+			passkeys.POST("/assertion/options", getPasskeyAssertionOptions)
+			passkeys.GET("/credentials", listPasskeys)
+			passkeys.DELETE("/credentials/:credentialId", deletePasskey)
+		}
+
+		// 112 ERSS interop
+		erssRoutes := api.Group("/erss")
+		{
+			erssRoutes.GET("/exchanges/:subjectId", listERSSExchanges)
+			erssRoutes.GET("/failed", listFailedERSSPushes)
+			erssRoutes.POST("/failed/:id/retry", retryERSSPush)
+		}
+
+		// Consent management
+		consents := api.Group("/consents")
+		{
+			consents.GET("/:id", listConsents)
+			consents.GET("/:id/check", checkConsent)
+			consents.GET("/:id/history", getConsentHistory)
+			consents.GET("/:id/receipts", listConsentReceipts)
+			consents.GET("/:id/receipts/:receiptId", getConsentReceipt)
+			consents.POST("/:id/receipts", issueConsentReceipt)
+			consents.POST("/:id/grant", consentHandler("grant", ledger.GrantConsent))
+			consents.POST("/:id/renew", consentHandler("renew", ledger.RenewConsent))
+			consents.POST("/:id/withdraw", consentHandler("withdraw", ledger.WithdrawConsent))
+		}
+
+		// Police dashboard
+		dashboard := api.Group("/dashboard")
+		{
+			dashboard.GET("/counts", getLiveCounts)
+			dashboard.GET("/clusters", getIncidentClusters)
+			dashboard.GET("/duplicates", getDuplicateClusters)
+			dashboard.GET("/responders", getResponderBoard)
+			dashboard.GET("/units", getUnitLayer)
+			dashboard.GET("/districts/:district", getDistrictDetail)
+		}
+
 		// Audit routes
 		audit := api.Group("/audit")
 		{
+			audit.GET("", searchAudits)
+			audit.GET("/export", exportAudits)
+			audit.GET("/compliance", getComplianceReport)
 			audit.GET("/:targetId", getAuditsByTarget)
 		}
+
+		// Bulk extraction for auditors
+		api.GET("/export/:docType", exportDocuments)
+
+		// Device keys for request signing
+		api.POST("/devices/keys", registerDeviceKey)
+		api.DELETE("/devices/keys/:deviceId", revokeDeviceKey)
+
+		// Tourist app screens, one round trip each
+		api.GET("/me/summary", getMySummary)
+
+		// Certificates for kiosk devices and officers, issued by the Fabric CA once approved
+		enroll := api.Group("/enrollments")
+		{
+			enroll.POST("/requests", submitEnrollment)
+			enroll.GET("/requests/:id", getEnrollment)
+			enroll.POST("/requests/:id/approve", reviewEnrollment(true))
+			enroll.POST("/requests/:id/reject", reviewEnrollment(false))
+			enroll.GET("/review", listEnrollmentReviewQueue)
+		}
+
+		// Access policy administration
+		admin := api.Group("/admin")
+		{
+			admin.GET("/policy", getAccessPolicy)
+			admin.PUT("/policy", updateAccessPolicy)
+			admin.POST("/policy/reload", reloadAccessPolicy)
+			admin.GET("/permissions", getPermissions)
+			admin.GET("/officers", listOfficers)
+			admin.POST("/officers", provisionOfficer)
+			admin.DELETE("/officers/:label", deprovisionOfficer)
+			admin.POST("/evidence-keys/rewrap", rewrapEvidenceKeys)
+			admin.POST("/evidence-keys/:incidentId/rotate", rotateEvidenceKey)
+		}
 	}
 
 	return r
@@ -237,295 +1024,460 @@ func setupRouter() *gin.Engine {
 // DID CRUD Operations
 func createDID(c *gin.Context) {
 	var req CreateDIDRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !bindRequest(c, &req) {
 		return
 	}
+	setAuditTarget(c, req.DigitalID)
 
-	_, err := contract.SubmitTransaction("CreateDID", req.DigitalID, req.ConsentHash, req.ExpiresAt, req.Issuer)
+	result, err := ledger.CreateDID(c.Request.Context(), req)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to create DID: %v", err)})
+		respondServiceError(c, "Failed to create DID", err)
 		return
 	}
 
-	c.JSON(http.StatusCreated, gin.H{
-		"success":   true,
+	respondCommitted(c, http.StatusCreated, gin.H{
 		"message":   "DID created successfully",
 		"digitalID": req.DigitalID,
-	})
+	}, result)
 }
 
 func getDID(c *gin.Context) {
-	id := c.Param("id")
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
 
-	result, err := contract.EvaluateTransaction("ReadDID", id)
+	doc, err := ledger.GetDID(c.Request.Context(), id)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Failed to read DID: %v", err)})
+		respondServiceError(c, "Failed to read DID", err)
 		return
 	}
 
-	var did DIDDocument
-	if err := json.Unmarshal(result, &did); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse DID data"})
+	respondTagged(c, doc, doc.TxID)
+}
+
+func verifyDID(c *gin.Context) {
+	verdict, err := ledger.VerifyDID(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		respondServiceError(c, "Failed to verify DID", err)
 		return
 	}
 
-	c.JSON(http.StatusOK, did)
+	respondData(c, http.StatusOK, verdict)
 }
 
 func updateDID(c *gin.Context) {
-	id := c.Param("id")
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
 	var req UpdateDIDRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !bindRequest(c, &req) {
 		return
 	}
 
-	_, err := contract.SubmitTransaction("UpdateDID", id, req.ConsentHash, req.ExpiresAt, req.Updater)
+	result, err := ledger.UpdateDID(c.Request.Context(), id, req)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to update DID: %v", err)})
+		respondServiceError(c, "Failed to update DID", err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"success":   true,
+	respondCommitted(c, http.StatusOK, gin.H{
 		"message":   "DID updated successfully",
 		"digitalID": id,
-	})
+	}, result)
 }
 
 func deleteDID(c *gin.Context) {
-	id := c.Param("id")
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
 	var req DeleteRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !bindRequest(c, &req) {
 		return
 	}
 
-	_, err := contract.SubmitTransaction("DeleteDID", id, req.Actor)
+	result, err := ledger.DeleteDID(c.Request.Context(), id, req)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to delete DID: %v", err)})
+		respondServiceError(c, "Failed to delete DID", err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"success":   true,
+	respondCommitted(c, http.StatusOK, gin.H{
 		"message":   "DID deleted successfully",
 		"digitalID": id,
-	})
+	}, result)
 }
 
 // Incident CRUD Operations
 func createIncident(c *gin.Context) {
 	var req CreateIncidentRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !bindRequest(c, &req) {
 		return
 	}
+	setAuditTarget(c, req.IncidentID)
 
-	_, err := contract.SubmitTransaction("CreateIncident", req.IncidentID, req.IncidentSummaryHash, req.Reporter)
+	result, err := ledger.CreateIncident(c.Request.Context(), req)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to create incident: %v", err)})
+		respondServiceError(c, "Failed to create incident", err)
 		return
 	}
 
-	c.JSON(http.StatusCreated, gin.H{
-		"success":    true,
+	respondCommitted(c, http.StatusCreated, gin.H{
 		"message":    "Incident created successfully",
 		"incidentID": req.IncidentID,
-	})
+	}, result)
 }
 
 func getIncident(c *gin.Context) {
-	id := c.Param("id")
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
 
-	result, err := contract.EvaluateTransaction("ReadIncident", id)
+	doc, err := ledger.GetIncident(c.Request.Context(), id)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Failed to read incident: %v", err)})
+		respondServiceError(c, "Failed to read incident", err)
 		return
 	}
 
-	var incident IncidentDocument
-	if err := json.Unmarshal(result, &incident); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse incident data"})
+	respondTagged(c, doc, doc.TxID)
+}
+
+func searchIncidents(c *gin.Context) {
+	var req IncidentSearchRequest
+	if !bindQuery(c, &req) {
+		return
+	}
+
+	page, err := ledger.SearchIncidents(c.Request.Context(), req)
+	if err != nil {
+		respondServiceError(c, "Failed to search incidents", err)
 		return
 	}
 
-	c.JSON(http.StatusOK, incident)
+	txIDs := make([]string, 0, len(page.Incidents)+1)
+	for _, incident := range page.Incidents {
+		txIDs = append(txIDs, incident.TxID)
+	}
+	respondTagged(c, page, append(txIDs, page.Bookmark)...)
 }
 
 func updateIncident(c *gin.Context) {
-	id := c.Param("id")
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
 	var req UpdateIncidentRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !bindRequest(c, &req) {
 		return
 	}
 
-	_, err := contract.SubmitTransaction("UpdateIncident", id, req.IncidentSummaryHash, req.Updater)
+	result, err := ledger.UpdateIncident(c.Request.Context(), id, req)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to update incident: %v", err)})
+		respondServiceError(c, "Failed to update incident", err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"success":    true,
+	respondCommitted(c, http.StatusOK, gin.H{
 		"message":    "Incident updated successfully",
 		"incidentID": id,
-	})
+	}, result)
+}
+
+func updateIncidentStatus(c *gin.Context) {
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	var req UpdateIncidentStatusRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+
+	result, err := ledger.UpdateIncidentStatus(c.Request.Context(), id, req)
+	if err != nil {
+		respondServiceError(c, "Failed to update incident status", err)
+		return
+	}
+
+	respondCommitted(c, http.StatusOK, gin.H{
+		"message":    "Incident status updated successfully",
+		"incidentID": id,
+		"status":     req.Status,
+	}, result)
+}
+
+func mergeIncidents(c *gin.Context) {
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	var req MergeIncidentsRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+
+	result, err := ledger.MergeIncidents(c.Request.Context(), id, req)
+	if err != nil {
+		respondServiceError(c, "Failed to merge incidents", err)
+		return
+	}
+
+	respondCommitted(c, http.StatusOK, gin.H{
+		"message":    "Incidents merged successfully",
+		"incidentID": id,
+	}, result)
 }
 
 func deleteIncident(c *gin.Context) {
-	id := c.Param("id")
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
 	var req DeleteRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !bindRequest(c, &req) {
 		return
 	}
 
-	_, err := contract.SubmitTransaction("DeleteIncident", id, req.Actor)
+	result, err := ledger.DeleteIncident(c.Request.Context(), id, req)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to delete incident: %v", err)})
+		respondServiceError(c, "Failed to delete incident", err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"success":    true,
+	respondCommitted(c, http.StatusOK, gin.H{
 		"message":    "Incident deleted successfully",
 		"incidentID": id,
-	})
+	}, result)
 }
 
 // Evidence CRUD Operations
 func createEvidence(c *gin.Context) {
 	var req CreateEvidenceRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !bindRequest(c, &req) {
 		return
 	}
+	setAuditTarget(c, req.EvidenceID)
 
-	_, err := contract.SubmitTransaction("CreateEvidence", req.EvidenceID, req.EvidenceHash, req.IncidentID, req.MediaType, req.UploadedBy)
+	result, err := ledger.CreateEvidence(c.Request.Context(), req)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to create evidence: %v", err)})
+		respondServiceError(c, "Failed to create evidence", err)
 		return
 	}
 
-	c.JSON(http.StatusCreated, gin.H{
-		"success":    true,
+	respondCommitted(c, http.StatusCreated, gin.H{
 		"message":    "Evidence created successfully",
 		"evidenceID": req.EvidenceID,
-	})
+	}, result)
 }
 
 func getEvidence(c *gin.Context) {
-	id := c.Param("id")
-
-	result, err := contract.EvaluateTransaction("ReadEvidence", id)
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Failed to read evidence: %v", err)})
+	id, ok := validPathID(c, "id")
+	if !ok {
 		return
 	}
 
-	var evidence EvidenceDocument
-	if err := json.Unmarshal(result, &evidence); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse evidence data"})
+	doc, err := ledger.GetEvidence(c.Request.Context(), id)
+	if err != nil {
+		respondServiceError(c, "Failed to read evidence", err)
 		return
 	}
 
-	c.JSON(http.StatusOK, evidence)
+	respondTagged(c, doc, doc.TxID)
 }
 
 func updateEvidence(c *gin.Context) {
-	id := c.Param("id")
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
 	var req UpdateEvidenceRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !bindRequest(c, &req) {
 		return
 	}
 
-	_, err := contract.SubmitTransaction("UpdateEvidence", id, req.EvidenceHash, req.MediaType, req.Updater)
+	result, err := ledger.UpdateEvidence(c.Request.Context(), id, req)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to update evidence: %v", err)})
+		respondServiceError(c, "Failed to update evidence", err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"success":    true,
+	respondCommitted(c, http.StatusOK, gin.H{
 		"message":    "Evidence updated successfully",
 		"evidenceID": id,
-	})
+	}, result)
 }
 
 func deleteEvidence(c *gin.Context) {
-	id := c.Param("id")
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
 	var req DeleteRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !bindRequest(c, &req) {
 		return
 	}
 
-	_, err := contract.SubmitTransaction("DeleteEvidence", id, req.Actor)
+	result, err := ledger.DeleteEvidence(c.Request.Context(), id, req)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to delete evidence: %v", err)})
+		respondServiceError(c, "Failed to delete evidence", err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"success":    true,
+	respondCommitted(c, http.StatusOK, gin.H{
 		"message":    "Evidence deleted successfully",
 		"evidenceID": id,
-	})
+	}, result)
 }
 
 func getEvidenceByIncident(c *gin.Context) {
-	incidentId := c.Param("incidentId")
+	incidentId, ok := validPathID(c, "incidentId")
+	if !ok {
+		return
+	}
 
-	result, err := contract.EvaluateTransaction("GetEvidenceByIncident", incidentId)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to get evidence by incident: %v", err)})
+	var req EvidenceListRequest
+	if !bindQuery(c, &req) {
 		return
 	}
 
-	var evidenceList []EvidenceDocument
-	if err := json.Unmarshal(result, &evidenceList); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse evidence list data"})
+	docs, err := ledger.ListEvidenceByIncident(c.Request.Context(), incidentId, req)
+	if err != nil {
+		respondServiceError(c, "Failed to get evidence by incident", err)
 		return
 	}
 
-	c.JSON(http.StatusOK, evidenceList)
+	txIDs := make([]string, 0, len(docs))
+	for _, doc := range docs {
+		txIDs = append(txIDs, doc.TxID)
+	}
+	respondTagged(c, docs, txIDs...)
 }
 
 // Audit Operations
 func getAuditsByTarget(c *gin.Context) {
-	targetId := c.Param("targetId")
+	targetId, ok := validPathID(c, "targetId")
+	if !ok {
+		return
+	}
 
-	result, err := contract.EvaluateTransaction("GetAuditsByTarget", targetId)
+	docs, err := ledger.ListAuditsByTarget(c.Request.Context(), targetId)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to get audit logs: %v", err)})
+		respondServiceError(c, "Failed to get audit logs", err)
 		return
 	}
 
-	var auditList []AuditDocument
-	if err := json.Unmarshal(result, &auditList); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse audit list data"})
-		return
+	respondData(c, http.StatusOK, docs)
+}
+
+// startChaincodeEventListening follows one target's events, reconnecting when the stream ends, as
+// it does when the connection fails over to another peer. Health reporting tracks the default
+// target only, since block numbers are not comparable across channels.
+func startChaincodeEventListening(ctx context.Context, target *fabricTarget) {
+	log.Printf("📡 Starting chaincode event listening on %s/%s...", target.Channel, target.Chaincode)
+	isDefault := target == defaultTarget
+	if isDefault {
+		eventListenerRunning.Store(true)
+		defer eventListenerRunning.Store(false)
 	}
 
-	c.JSON(http.StatusOK, auditList)
+	ctx = withTarget(ctx, target)
+	backoff := time.Second
+	for ctx.Err() == nil {
+		err := listenChaincodeEvents(ctx, target, isDefault)
+		if ctx.Err() != nil {
+			return
+		}
+		if errors.Is(err, errLeadershipChanged) {
+			backoff = time.Second
+			continue
+		}
+		log.Printf("📡 Chaincode event listening on %s stopped, retrying in %s: %v", target.Name, backoff, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, time.Minute)
+	}
 }
 
-func startChaincodeEventListening(ctx context.Context, network *client.Network) {
-	log.Println("📡 Starting chaincode event listening...")
+// listenChaincodeEvents runs one event stream. Every replica keeps its in-memory views current; the
+// event leader also runs the side effects, resuming from its checkpoint so events that arrived
+// while no replica led are not missed.
+func listenChaincodeEvents(ctx context.Context, target *fabricTarget, isDefault bool) error {
+	leading, changed := eventLeadership.watch()
+	var options []client.ChaincodeEventsOption
+	checkpointing := leading && eventLeadership != nil
+	if checkpointing {
+		checkpoint, err := loadEventCheckpoint(ctx, target)
+		if err != nil {
+			return fmt.Errorf("failed to load event checkpoint: %w", err)
+		}
+		if checkpoint != nil {
+			options = append(options, client.WithCheckpoint(checkpoint))
+		}
+	}
 
-	events, err := network.ChaincodeEvents(ctx, chaincodeName)
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	events, err := target.network.ChaincodeEvents(streamCtx, target.Chaincode, options...)
 	if err != nil {
-		log.Printf("Failed to start chaincode event listening: %v", err)
-		return
+		return err
+	}
+	fabricMonitor.listening(target.Name, true)
+	defer fabricMonitor.listening(target.Name, false)
+	if isDefault && didValidities != nil {
+		// The height is read once subscribed, so any change in a block below it is on the peers
+		// when the cache reads them
+		if height, err := channelHeight(ctx, target); err != nil {
+			log.Printf("🔖 DID validity cache off until the event stream restarts: %v", err)
+		} else {
+			didValidities.resume(height)
+			defer didValidities.suspend()
+		}
 	}
 
-	for event := range events {
-		asset := formatJSON(event.Payload)
-		log.Printf("🎯 Chaincode event received: %s - %s", event.EventName, asset)
+	for {
+		select {
+		case <-changed:
+			return errLeadershipChanged
+		case event, ok := <-events:
+			if !ok {
+				return errors.New("event stream closed")
+			}
+			if isDefault {
+				recordEventBlock(event.BlockNumber)
+			}
+			fabricMonitor.event(target.Name, event.BlockNumber, time.Now())
+			geofenceFromEvent(ctx, event)
+			orgGrantsFromEvent(ctx, event)
+			devicesFromEvent(ctx, event)
+			dashboardFromEvent(ctx, event)
+			didValidityFromEvent(ctx, event)
+			if !leading {
+				continue
+			}
+			invalidateFromEvent(ctx, event)
+			pushFromEvent(ctx, event)
+			operatorsFromEvent(ctx, event)
+			emailFromEvent(ctx, event)
+			orchestrateFromEvent(ctx, event)
+			dedupFromEvent(ctx, event)
+			credentialsFromEvent(ctx, event)
+			revocationsFromEvent(ctx, event)
+			broadcastsFromEvent(ctx, event)
+			workflowsFromEvent(ctx, event)
+			asset := formatJSON(event.Payload)
+			log.Printf("🎯 Chaincode event received on %s: %s - %s", target.Name, event.EventName, asset)
+			if checkpointing {
+				if err := saveEventCheckpoint(ctx, target, event); err != nil {
+					log.Printf("📡 Failed to checkpoint event %s on %s: %v", event.TransactionID, target.Name, err)
+				}
+			}
+		}
 	}
 }
 
@@ -536,3 +1488,18 @@ func formatJSON(data []byte) string {
 	}
 	return result.String()
 }
+
+func searchAudits(c *gin.Context) {
+	var req AuditSearchRequest
+	if !bindQuery(c, &req) {
+		return
+	}
+
+	page, err := ledger.SearchAudits(c.Request.Context(), req)
+	if err != nil {
+		respondServiceError(c, "Failed to search audit logs", err)
+		return
+	}
+
+	respondData(c, http.StatusOK, page)
+}
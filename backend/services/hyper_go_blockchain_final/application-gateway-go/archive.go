@@ -0,0 +1,578 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	errCodeArchiveDisabled = "ARCHIVE_DISABLED"
+	errCodeArchiveTampered = "ARCHIVE_TAMPERED"
+
+	// archiveFormat names the bundle layout, for verifiers written against it
+	archiveFormat = "sih-incident-archive/1"
+
+	maxArchiveBatch = 1000
+)
+
+// IncidentArchive is the ledger record of an incident's archive bundle in cold storage
+type IncidentArchive struct {
+	DocType    string `json:"doc_type"`
+	ArchiveID  string `json:"archive_id"`
+	IncidentID string `json:"incident_id"`
+	Digest     string `json:"digest"`
+	ObjectKey  string `json:"object_key"`
+	Size       int    `json:"size"`
+	ProofCount int    `json:"proof_count"`
+	ArchivedBy string `json:"archived_by"`
+	ArchivedAt string `json:"archived_at"`
+	OwnerOrg   string `json:"owner_org,omitempty"`
+	TxID       string `json:"tx_id"`
+}
+
+// ArchiveBlock is a block that committed a transaction in the bundle, with its header fields and
+// every transaction envelope in it as stored, so both of its hashes can be recomputed
+type ArchiveBlock struct {
+	Number       uint64   `json:"number"`
+	Hash         string   `json:"hash"`
+	PreviousHash string   `json:"previous_hash"`
+	DataHash     string   `json:"data_hash"`
+	Data         [][]byte `json:"data"`
+}
+
+// ArchiveProof places a transaction in the bundle at its position in a block. The validation code
+// is the peers' verdict, which block metadata carries outside the hashes.
+type ArchiveProof struct {
+	TxID           string `json:"tx_id"`
+	BlockNumber    uint64 `json:"block_number"`
+	Index          int    `json:"index"`
+	ValidationCode string `json:"validation_code"`
+	Valid          bool   `json:"valid"`
+}
+
+// IncidentArchiveBundle is what cold storage keeps of an incident: its ledger versions, evidence
+// records and audit entries, and a proof for every transaction that wrote them. The evidence files
+// themselves stay in the evidence store, checked against the hashes recorded here.
+type IncidentArchiveBundle struct {
+	Format     string             `json:"format"`
+	ArchiveID  string             `json:"archive_id"`
+	IncidentID string             `json:"incident_id"`
+	Channel    string             `json:"channel"`
+	Chaincode  string             `json:"chaincode"`
+	Incident   IncidentDocument   `json:"incident"`
+	Versions   []IncidentVersion  `json:"versions"`
+	Evidence   []EvidenceDocument `json:"evidence"`
+	Audits     []AuditDocument    `json:"audits"`
+	Proofs     []ArchiveProof     `json:"proofs"`
+	Blocks     []ArchiveBlock     `json:"blocks"`
+}
+
+// archiveService moves incidents resolved more than ARCHIVE_AFTER_MONTHS ago out of the off-chain
+// index into cold storage, anchoring each bundle's digest on the ledger
+type archiveService struct {
+	months   int
+	interval time.Duration
+	batch    int
+	actor    string
+}
+
+var (
+	archiver     *archiveService
+	archiveStore EvidenceStore
+)
+
+// initArchive runs after initOffchainIndex. Archival only moves incidents out of the index, so it
+// needs one.
+func initArchive() {
+	if !getEnvBool("ARCHIVE_ENABLED", false) {
+		log.Println("🧊 ARCHIVE_ENABLED not set, resolved incidents stay in the off-chain index")
+		return
+	}
+	if offchain == nil {
+		panic(errors.New("ARCHIVE_ENABLED requires the off-chain index; set INDEX_DATABASE_URL"))
+	}
+	a := &archiveService{
+		months:   getEnvInt("ARCHIVE_AFTER_MONTHS", 12),
+		interval: getEnvDuration("ARCHIVE_INTERVAL", 24*time.Hour),
+		batch:    getEnvInt("ARCHIVE_BATCH", 100),
+		actor:    getEnv("ARCHIVE_ACTOR", "incident-archiver"),
+	}
+	if a.months <= 0 || a.interval <= 0 || a.batch <= 0 || a.batch > maxArchiveBatch {
+		panic(fmt.Errorf("ARCHIVE_AFTER_MONTHS and ARCHIVE_INTERVAL must be positive and ARCHIVE_BATCH between 1 and %d", maxArchiveBatch))
+	}
+	initArchiveStore()
+	archiver = a
+	log.Printf("🧊 Archiving incidents resolved more than %d months ago every %s", a.months, a.interval)
+}
+
+// initArchiveStore opens the cold store, a directory or a bucket of its own so a lifecycle policy
+// can move it to a colder tier without touching live evidence
+func initArchiveStore() {
+	switch backend := getEnv("ARCHIVE_STORAGE", "local"); backend {
+	case "local":
+		dir := getEnv("ARCHIVE_STORAGE_DIR", "./archive-store")
+		store, err := newLocalStore(dir)
+		if err != nil {
+			panic(fmt.Errorf("failed to initialise local archive store: %w", err))
+		}
+		archiveStore = store
+		log.Printf("🧊 Storing incident archives in %s", dir)
+	case "s3", "minio":
+		store, err := newS3StoreFromEnv()
+		if err != nil {
+			panic(fmt.Errorf("failed to initialise S3 archive store: %w", err))
+		}
+		store.bucket = getEnv("ARCHIVE_S3_BUCKET", store.bucket)
+		archiveStore = store
+		log.Printf("🧊 Storing incident archives in bucket %s at %s", store.bucket, store.endpoint)
+	default:
+		panic(fmt.Errorf("unknown ARCHIVE_STORAGE backend %q", backend))
+	}
+}
+
+// archiveObjectKey is where an archive bundle lives in the cold store
+func archiveObjectKey(incidentID, archiveID string) string {
+	return fmt.Sprintf("archive/%s/%s.json", incidentID, archiveID)
+}
+
+// incidentArchiveID is deterministic for the indexed version of the incident, so instances that
+// pick the same incident agree on the archive, and an incident written to after archival is
+// archived anew
+func incidentArchiveID(incidentID string, block uint64) string {
+	return fmt.Sprintf("ARC:%s:%d", incidentID, block)
+}
+
+// archiveCandidate is an indexed incident due for archival and the block of its indexed version
+type archiveCandidate struct {
+	incidentID string
+	block      uint64
+}
+
+// archiveCandidates returns the resolved or closed incidents, oldest first, that were resolved
+// before cutoff. Incidents closed without a resolution time count from when they were reported.
+func (ix *offchainIndex) archiveCandidates(ctx context.Context, cutoff time.Time, limit int) ([]archiveCandidate, error) {
+	rows, err := ix.db.Query(ctx, fmt.Sprintf(`SELECT incident_id, block_number FROM incidents
+		WHERE deleted_at IS NULL AND status IN ('resolved', 'closed') AND COALESCE(resolved_at, created_at) < $1
+		ORDER BY COALESCE(resolved_at, created_at), incident_id LIMIT %d`, limit), cutoff.UTC().Format(time.RFC3339))
+	if err != nil {
+		return nil, err
+	}
+	candidates := make([]archiveCandidate, 0, len(rows))
+	for _, row := range rows {
+		candidates = append(candidates, archiveCandidate{incidentID: row.String(0), block: uint64(row.Int(1))})
+	}
+	return candidates, nil
+}
+
+// archived reports whether an incident has left the hot tables for cold storage
+func (ix *offchainIndex) archived(ctx context.Context, incidentID string) (bool, error) {
+	rows, err := ix.db.Query(ctx, `SELECT 1 FROM incident_archives a WHERE a.incident_id = $1
+		AND NOT EXISTS (SELECT 1 FROM incidents i WHERE i.incident_id = a.incident_id) LIMIT 1`, incidentID)
+	return len(rows) > 0, err
+}
+
+// sweep archives a batch of the incidents due, each claimed so that only one instance builds its
+// bundle. The scheduler runs it every ARCHIVE_INTERVAL.
+func (a *archiveService) sweep(ctx context.Context, now time.Time) error {
+	candidates, err := offchain.archiveCandidates(ctx, now.AddDate(0, -a.months, 0), a.batch)
+	if err != nil {
+		return fmt.Errorf("failed to select incidents to archive: %w", err)
+	}
+	failed := 0
+	for _, candidate := range candidates {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		id := incidentArchiveID(candidate.incidentID, candidate.block)
+		if claimed, err := claimEvent(ctx, "archive:"+id, a.interval); err != nil || !claimed {
+			continue
+		}
+		if err := a.archive(ctx, candidate.incidentID, id); err != nil {
+			log.Printf("🧊 Failed to archive incident %s: %v", candidate.incidentID, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to archive %d of %d incidents", failed, len(candidates))
+	}
+	return nil
+}
+
+// archive stores the incident's bundle and anchors its digest. The index drops the incident when it
+// projects the anchor, so a bundle the ledger has no record of leaves the incident hot.
+func (a *archiveService) archive(ctx context.Context, incidentID, archiveID string) error {
+	existing, err := incidentArchives(ctx, incidentID)
+	if err != nil {
+		return err
+	}
+	for _, archive := range existing {
+		if archive.ArchiveID == archiveID {
+			return nil
+		}
+	}
+
+	bundle, err := buildArchiveBundle(ctx, incidentID, archiveID)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(bundle)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(body)
+	digest := hex.EncodeToString(sum[:])
+	key := archiveObjectKey(incidentID, archiveID)
+
+	if err := archiveStore.Put(ctx, key, bytes.NewReader(body), int64(len(body)), "application/json"); err != nil {
+		return fmt.Errorf("failed to store archive bundle: %w", err)
+	}
+	result, err := submitTransaction(ctx, "RecordIncidentArchive", archiveID, incidentID, digest, key,
+		strconv.Itoa(len(body)), strconv.Itoa(len(bundle.Proofs)), a.actor)
+	if err != nil {
+		if delErr := archiveStore.Delete(ctx, key); delErr != nil {
+			log.Printf("🧊 Failed to remove orphaned archive %s: %v", key, delErr)
+		}
+		return err
+	}
+	log.Printf("🧊 Archived incident %s with %d proofs in transaction %s", incidentID, len(bundle.Proofs), result.TxID)
+	return nil
+}
+
+// buildArchiveBundle reads the incident's committed history from the ledger, never the index, and
+// the block that committed each transaction in it
+func buildArchiveBundle(ctx context.Context, incidentID, archiveID string) (*IncidentArchiveBundle, error) {
+	versions, err := incidentHistory(ctx, incidentID)
+	if err != nil {
+		return nil, err
+	}
+	if len(versions) == 0 || versions[len(versions)-1].Deleted || versions[len(versions)-1].Incident == nil {
+		return nil, fmt.Errorf("the incident %s has no current version", incidentID)
+	}
+	evidence, err := evidenceByIncidentFromLedger(ctx, incidentID, EvidenceListRequest{})
+	if err != nil {
+		return nil, err
+	}
+	audits, err := auditsByTargetFromLedger(ctx, incidentID)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range evidence {
+		evidenceAudits, err := auditsByTargetFromLedger(ctx, e.EvidenceID)
+		if err != nil {
+			return nil, err
+		}
+		audits = append(audits, evidenceAudits...)
+	}
+
+	target := targetFromContext(ctx)
+	bundle := &IncidentArchiveBundle{
+		Format:     archiveFormat,
+		ArchiveID:  archiveID,
+		IncidentID: incidentID,
+		Channel:    target.Channel,
+		Chaincode:  target.Chaincode,
+		Incident:   *versions[len(versions)-1].Incident,
+		Versions:   versions,
+		Evidence:   evidence,
+		Audits:     audits,
+		Proofs:     []ArchiveProof{},
+		Blocks:     []ArchiveBlock{},
+	}
+	blocks := map[uint64]bool{}
+	for _, txID := range bundle.txIDs() {
+		block, err := readBlockByTxID(ctx, txID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the block of transaction %s: %w", txID, err)
+		}
+		proof, ok := archiveProof(block, txID)
+		if !ok {
+			return nil, fmt.Errorf("transaction %s is not in block %d", txID, block.GetHeader().GetNumber())
+		}
+		bundle.Proofs = append(bundle.Proofs, proof)
+		if !blocks[proof.BlockNumber] {
+			blocks[proof.BlockNumber] = true
+			bundle.Blocks = append(bundle.Blocks, archiveBlock(block))
+		}
+	}
+	sort.Slice(bundle.Blocks, func(i, j int) bool { return bundle.Blocks[i].Number < bundle.Blocks[j].Number })
+	return bundle, nil
+}
+
+// txIDs lists each transaction that wrote a document in the bundle once, in bundle order. Entries
+// written without a transaction ID have nothing to prove.
+func (b *IncidentArchiveBundle) txIDs() []string {
+	var ids []string
+	seen := map[string]bool{}
+	add := func(txID string) {
+		if txID != "" && !seen[txID] {
+			seen[txID] = true
+			ids = append(ids, txID)
+		}
+	}
+	for _, v := range b.Versions {
+		add(v.TxID)
+	}
+	for _, e := range b.Evidence {
+		add(e.TxID)
+	}
+	for _, a := range b.Audits {
+		add(a.TxID)
+	}
+	return ids
+}
+
+func archiveBlock(block *common.Block) ArchiveBlock {
+	header := block.GetHeader()
+	return ArchiveBlock{
+		Number:       header.GetNumber(),
+		Hash:         hex.EncodeToString(blockHeaderHash(header)),
+		PreviousHash: hex.EncodeToString(header.GetPreviousHash()),
+		DataHash:     hex.EncodeToString(header.GetDataHash()),
+		Data:         block.GetData().GetData(),
+	}
+}
+
+// archiveProof finds a transaction in its block
+func archiveProof(block *common.Block, txID string) (ArchiveProof, bool) {
+	for i, data := range block.GetData().GetData() {
+		var envelope common.Envelope
+		if err := proto.Unmarshal(data, &envelope); err != nil || decodeEnvelope(&envelope).TxID != txID {
+			continue
+		}
+		code := txValidationCode(block, i)
+		return ArchiveProof{
+			TxID: txID, BlockNumber: block.GetHeader().GetNumber(), Index: i,
+			ValidationCode: code.String(), Valid: code == peer.TxValidationCode_VALID,
+		}, true
+	}
+	return ArchiveProof{}, false
+}
+
+// verifyArchiveBundle checks that a bundle holds together without a peer: every block's envelopes
+// hash to its data hash and its header to its hash, consecutive blocks chain, and every transaction
+// behind a document in the bundle is at its stated position in a block. Whether the blocks are the
+// channel's is checked by comparing their hashes with a peer's.
+func verifyArchiveBundle(bundle *IncidentArchiveBundle) error {
+	blocks := map[uint64]ArchiveBlock{}
+	for i, b := range bundle.Blocks {
+		dataHash, err := hex.DecodeString(b.DataHash)
+		if err != nil || hex.EncodeToString(blockDataHash(&common.BlockData{Data: b.Data})) != b.DataHash {
+			return fmt.Errorf("block %d: its transactions do not match its data hash", b.Number)
+		}
+		previousHash, err := hex.DecodeString(b.PreviousHash)
+		header := &common.BlockHeader{Number: b.Number, PreviousHash: previousHash, DataHash: dataHash}
+		if err != nil || hex.EncodeToString(blockHeaderHash(header)) != b.Hash {
+			return fmt.Errorf("block %d: its header does not match its hash", b.Number)
+		}
+		if i > 0 && bundle.Blocks[i-1].Number+1 == b.Number && bundle.Blocks[i-1].Hash != b.PreviousHash {
+			return fmt.Errorf("block %d does not follow block %d", b.Number, bundle.Blocks[i-1].Number)
+		}
+		blocks[b.Number] = b
+	}
+
+	proven := map[string]bool{}
+	for _, p := range bundle.Proofs {
+		b, ok := blocks[p.BlockNumber]
+		if !ok || p.Index < 0 || p.Index >= len(b.Data) {
+			return fmt.Errorf("transaction %s: block %d is not in the bundle", p.TxID, p.BlockNumber)
+		}
+		var envelope common.Envelope
+		if err := proto.Unmarshal(b.Data[p.Index], &envelope); err != nil || decodeEnvelope(&envelope).TxID != p.TxID {
+			return fmt.Errorf("transaction %s is not at position %d of block %d", p.TxID, p.Index, p.BlockNumber)
+		}
+		proven[p.TxID] = true
+	}
+	for _, txID := range bundle.txIDs() {
+		if !proven[txID] {
+			return fmt.Errorf("transaction %s has no proof", txID)
+		}
+	}
+	return nil
+}
+
+// readArchiveObject reads a stored bundle and its SHA-256
+func readArchiveObject(ctx context.Context, key string) ([]byte, string, error) {
+	object, err := archiveStore.Get(ctx, key)
+	if err != nil {
+		return nil, "", err
+	}
+	defer object.Close()
+	body, err := io.ReadAll(object)
+	if err != nil {
+		return nil, "", err
+	}
+	sum := sha256.Sum256(body)
+	return body, hex.EncodeToString(sum[:]), nil
+}
+
+// checkArchive compares a stored bundle with its anchored digest, checks that its proofs hold
+// together, and that its blocks are the ones the ledger holds
+func checkArchive(ctx context.Context, archive IncidentArchive) IntegrityItem {
+	item := IntegrityItem{
+		Kind: "archive", ID: archive.ArchiveID, IncidentID: archive.IncidentID,
+		StorageKey: archive.ObjectKey, AnchoredHash: archive.Digest, AnchorTxID: archive.TxID,
+	}
+	body, stored, err := readArchiveObject(ctx, archive.ObjectKey)
+	switch {
+	case errors.Is(err, errObjectNotFound):
+		item.Status, item.Detail = integrityMissing, "no stored bundle"
+		return item
+	case err != nil:
+		item.Status, item.Detail = integrityUnreadable, err.Error()
+		return item
+	}
+	item.StoredHash = stored
+	if stored != archive.Digest {
+		item.Status = integrityMismatch
+		return item
+	}
+
+	var bundle IncidentArchiveBundle
+	if err := json.Unmarshal(body, &bundle); err != nil {
+		item.Status, item.Detail = integrityMismatch, "the bundle does not parse: "+err.Error()
+		return item
+	}
+	if err := verifyArchiveBundle(&bundle); err != nil {
+		item.Status, item.Detail = integrityMismatch, err.Error()
+		return item
+	}
+	for _, b := range bundle.Blocks {
+		block, err := readBlock(ctx, b.Number)
+		if err != nil {
+			item.Status, item.Detail = integrityUnreadable, err.Error()
+			return item
+		}
+		if hex.EncodeToString(blockHeaderHash(block.GetHeader())) != b.Hash {
+			item.Status, item.Detail = integrityMismatch, fmt.Sprintf("block %d differs from the ledger", b.Number)
+			return item
+		}
+	}
+	item.Status = integrityVerified
+	return item
+}
+
+func (k *integrityChecker) checkArchives(ctx context.Context, report *IntegrityReport) error {
+	return k.walk(ctx, "incident_archive", func(doc json.RawMessage) error {
+		archive, err := decodeDocument[IncidentArchive](doc, "incident archive")
+		if err != nil {
+			return err
+		}
+		if k.incidentID != "" && archive.IncidentID != k.incidentID {
+			return nil
+		}
+		report.add(checkArchive(ctx, archive))
+		return ctx.Err()
+	})
+}
+
+// incidentArchives reads the archives recorded for an incident, latest first
+func incidentArchives(ctx context.Context, incidentID string) ([]IncidentArchive, error) {
+	result, err := evaluateTransaction(ctx, "GetIncidentArchives", incidentID)
+	if err != nil {
+		return nil, err
+	}
+	archives, err := decodeDocument[[]IncidentArchive](result, "incident archive")
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(archives, func(i, j int) bool { return archives[i].ArchivedAt > archives[j].ArchivedAt })
+	return archives, nil
+}
+
+// ListIncidentArchives returns the archives recorded for an incident the caller's organization owns
+func (s ledgerService) ListIncidentArchives(ctx context.Context, incidentID string) ([]IncidentArchive, error) {
+	if _, err := s.ownedIncident(ctx, incidentID); err != nil {
+		return nil, err
+	}
+	return incidentArchives(ctx, incidentID)
+}
+
+func listIncidentArchives(c *gin.Context) {
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	archives, err := ledger.ListIncidentArchives(c.Request.Context(), id)
+	if err != nil {
+		respondServiceError(c, "Failed to list incident archives", err)
+		return
+	}
+	respondData(c, http.StatusOK, archives)
+}
+
+// downloadIncidentArchive re-hashes the stored bundle and only releases it when it matches the
+// digest anchored on the ledger
+func downloadIncidentArchive(c *gin.Context) {
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	archiveID, ok := validPathID(c, "archiveId")
+	if !ok {
+		return
+	}
+	if archiveStore == nil {
+		respondError(c, http.StatusServiceUnavailable, errCodeArchiveDisabled, "Incident archival is not enabled")
+		return
+	}
+	ctx := c.Request.Context()
+
+	archives, err := ledger.ListIncidentArchives(ctx, id)
+	if err != nil {
+		respondServiceError(c, "Failed to read incident archive", err)
+		return
+	}
+	var archive *IncidentArchive
+	for i := range archives {
+		if archives[i].ArchiveID == archiveID {
+			archive = &archives[i]
+		}
+	}
+	if archive == nil {
+		respondError(c, http.StatusNotFound, errCodeNotFound, fmt.Sprintf("No archive %s for incident %s", archiveID, id))
+		return
+	}
+
+	body, stored, err := readArchiveObject(ctx, archive.ObjectKey)
+	if errors.Is(err, errObjectNotFound) {
+		respondError(c, http.StatusNotFound, errCodeNotFound, fmt.Sprintf("No stored bundle for archive %s", archiveID))
+		return
+	}
+	if err != nil {
+		logWithContext(ctx, "Failed to read archive bundle %s: %v", archive.ObjectKey, err)
+		respondError(c, http.StatusServiceUnavailable, errCodeUnavailable, "Failed to read archive bundle from storage")
+		return
+	}
+	if stored != archive.Digest {
+		logWithContext(ctx, "⚠️  Archive %s digest mismatch: ledger=%s stored=%s", archiveID, archive.Digest, stored)
+		respondError(c, http.StatusConflict, errCodeArchiveTampered, "Stored archive bundle does not match the digest anchored on the ledger")
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.json"`, archiveID))
+	c.Header("X-Archive-Digest", archive.Digest)
+	c.Header("X-Transaction-ID", archive.TxID)
+	c.Data(http.StatusOK, "application/json", body)
+}
@@ -0,0 +1,116 @@
+package gateway
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+)
+
+// archiveTestBlock builds a validated block holding an envelope per transaction, chained to previous
+func archiveTestBlock(t *testing.T, number uint64, previous []byte, txIDs ...string) *common.Block {
+	data := &common.BlockData{}
+	var filter []byte
+	for _, txID := range txIDs {
+		header := &common.ChannelHeader{Type: int32(common.HeaderType_ENDORSER_TRANSACTION), TxId: txID}
+		payload := &common.Payload{Header: &common.Header{ChannelHeader: mustMarshal(t, header)}}
+		data.Data = append(data.Data, mustMarshal(t, &common.Envelope{Payload: mustMarshal(t, payload)}))
+		filter = append(filter, byte(peer.TxValidationCode_VALID))
+	}
+	return &common.Block{
+		Header:   &common.BlockHeader{Number: number, PreviousHash: previous, DataHash: blockDataHash(data)},
+		Data:     data,
+		Metadata: &common.BlockMetadata{Metadata: [][]byte{nil, nil, filter}},
+	}
+}
+
+func testArchiveBundle(t *testing.T) *IncidentArchiveBundle {
+	first := archiveTestBlock(t, 41, []byte("genesis"), "tx-other", "tx-create")
+	second := archiveTestBlock(t, 42, blockHeaderHash(first.GetHeader()), "tx-evidence", "tx-resolve")
+	incident := &IncidentDocument{IncidentID: "INC-1", Status: "resolved"}
+	bundle := &IncidentArchiveBundle{
+		Format:     archiveFormat,
+		ArchiveID:  incidentArchiveID("INC-1", 42),
+		IncidentID: "INC-1",
+		Incident:   *incident,
+		Versions:   []IncidentVersion{{TxID: "tx-create", Incident: incident}, {TxID: "tx-resolve", Incident: incident}},
+		Evidence:   []EvidenceDocument{{EvidenceID: "EV-1", IncidentID: "INC-1", TxID: "tx-evidence"}},
+		// An entry written without a transaction ID is kept unproven
+		Audits: []AuditDocument{{TargetID: "INC-1", Action: "CREATE_INCIDENT", TxID: "tx-create"}, {TargetID: "INC-1", Action: "LEGACY"}},
+		Blocks: []ArchiveBlock{archiveBlock(first), archiveBlock(second)},
+	}
+	for _, txID := range bundle.txIDs() {
+		block := first
+		if txID != "tx-create" {
+			block = second
+		}
+		proof, ok := archiveProof(block, txID)
+		if !ok {
+			t.Fatalf("expected %s in block %d", txID, block.GetHeader().GetNumber())
+		}
+		bundle.Proofs = append(bundle.Proofs, proof)
+	}
+	return bundle
+}
+
+func TestVerifyArchiveBundle(t *testing.T) {
+	bundle := testArchiveBundle(t)
+	if len(bundle.Proofs) != 3 || bundle.Proofs[2].Index != 0 || !bundle.Proofs[2].Valid {
+		t.Fatalf("expected three valid proofs, got %+v", bundle.Proofs)
+	}
+	if err := verifyArchiveBundle(bundle); err != nil {
+		t.Fatalf("expected the bundle to verify, got %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		tamper func(b *IncidentArchiveBundle)
+		want   string
+	}{
+		{"envelope", func(b *IncidentArchiveBundle) { b.Blocks[1].Data[0] = b.Blocks[0].Data[0] }, "do not match its data hash"},
+		{"header", func(b *IncidentArchiveBundle) { b.Blocks[0].PreviousHash = strings.Repeat("0", 64) }, "does not match its hash"},
+		{"chain", func(b *IncidentArchiveBundle) {
+			b.Blocks[0] = archiveBlock(archiveTestBlock(t, 41, []byte("fork"), "tx-other", "tx-create"))
+		}, "does not follow block 41"},
+		{"position", func(b *IncidentArchiveBundle) { b.Proofs[0].Index = 0 }, "not at position 0 of block 41"},
+		{"block", func(b *IncidentArchiveBundle) { b.Proofs[1].BlockNumber = 43 }, "block 43 is not in the bundle"},
+		{"unproven", func(b *IncidentArchiveBundle) { b.Audits[1].TxID = "tx-other-audit" }, "tx-other-audit has no proof"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := testArchiveBundle(t)
+			tt.tamper(b)
+			if err := verifyArchiveBundle(b); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestProjectIncidentArchive(t *testing.T) {
+	payload := `{"doc_type":"incident_archive","archive_id":"ARC:INC-1:42","incident_id":"INC-1","digest":"abc","object_key":"archive/INC-1/ARC:INC-1:42.json","archived_at":"2026-09-21T02:00:00Z"}`
+	q := &recordingQuerier{}
+	event := &client.ChaincodeEvent{EventName: "RecordIncidentArchive", Payload: []byte(payload), TransactionID: "tx9", BlockNumber: 50}
+	if err := projectEvent(context.Background(), q, event); err != nil {
+		t.Fatal(err)
+	}
+	if len(q.queries) != 3 || !strings.Contains(q.queries[0], "INSERT INTO incident_archives") ||
+		!strings.Contains(q.queries[1], "DELETE FROM evidence") || !strings.Contains(q.queries[2], "DELETE FROM incidents") {
+		t.Fatalf("expected the archive recorded and the incident dropped, got %v", q.queries)
+	}
+	if q.args[2][0] != "INC-1" || q.args[0][6] != "tx9" {
+		t.Errorf("unexpected arguments %v", q.args)
+	}
+
+	// sih-indexer projects the archived document the same way
+	q = &recordingQuerier{}
+	if err := projectWrite(context.Background(), q, ledgerWrite{TxID: "tx9", Key: "ARC:INC-1:42", Value: []byte(payload)}, 50); err != nil {
+		t.Fatal(err)
+	}
+	if len(q.queries) != 3 {
+		t.Errorf("expected the same statements from the write, got %v", q.queries)
+	}
+}
@@ -0,0 +1,669 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	accessTokenIssuer  = "sih-gateway"
+	tokenExchangeRoute = "/api/v1/auth/token"
+
+	sessionKeyPrefix        = "sih:session:"
+	revokedSessionKeyPrefix = "sih:session:revoked:"
+	subjectSessionsPrefix   = "sih:session:subject:"
+
+	errCodeTokenInvalid        = "TOKEN_INVALID"
+	errCodeTokenExpired        = "TOKEN_EXPIRED"
+	errCodeTokenRevoked        = "TOKEN_REVOKED"
+	errCodeTokenRequired       = "TOKEN_REQUIRED"
+	errCodeRefreshReused       = "REFRESH_TOKEN_REUSED"
+	errCodeSessionsDisabled    = "SESSIONS_DISABLED"
+	errCodeSessionsUnavailable = "SESSIONS_UNAVAILABLE"
+)
+
+// IssueTokenRequest exchanges the caller's API key for a session
+type IssueTokenRequest struct {
+	// DeviceID names the shared device the session was opened on, for the session list
+	DeviceID string `json:"device_id"`
+}
+
+func (r IssueTokenRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	if r.DeviceID != "" {
+		v.identifier("device_id", r.DeviceID)
+	}
+	return v.errors
+}
+
+// RefreshTokenRequest rotates a session's refresh token
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+func (r RefreshTokenRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	if _, _, ok := splitRefreshToken(r.RefreshToken); !ok {
+		v.add("refresh_token", "is not a refresh token")
+	}
+	return v.errors
+}
+
+// RevokeSessionsRequest ends every session of a wallet identity, the caller's own by default
+type RevokeSessionsRequest struct {
+	Wallet string `json:"wallet"`
+}
+
+func (r RevokeSessionsRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	if r.Wallet != "" {
+		v.identifier("wallet", r.Wallet)
+	}
+	return v.errors
+}
+
+// TokenResponse carries a fresh access token and the refresh token that replaces the previous one
+type TokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	ExpiresIn        int    `json:"expires_in"`
+	RefreshToken     string `json:"refresh_token"`
+	RefreshExpiresAt string `json:"refresh_expires_at"`
+	SessionID        string `json:"session_id"`
+}
+
+// Session is a login on one device. Refreshing rotates its token but never extends it past
+// ExpiresAt, so a session ends with the shift it was opened for.
+type Session struct {
+	ID          string `json:"id"`
+	Subject     string `json:"subject"`
+	DeviceID    string `json:"device_id,omitempty"`
+	CreatedAt   string `json:"created_at"`
+	RefreshedAt string `json:"refreshed_at,omitempty"`
+	ExpiresAt   string `json:"expires_at"`
+	// RefreshHash is the SHA-256 of the only refresh secret that is still valid
+	RefreshHash string `json:"refresh_hash"`
+}
+
+type loggedOut struct {
+	Message   string `json:"message"`
+	SessionID string `json:"session_id"`
+}
+
+type revokedSessions struct {
+	Message string `json:"message"`
+	Wallet  string `json:"wallet"`
+	// Revoked counts the sessions that were still live
+	Revoked int `json:"revoked"`
+}
+
+// accessClaims are the claims of an access token
+type accessClaims struct {
+	Issuer    string `json:"iss"`
+	Subject   string `json:"sub"`
+	SessionID string `json:"sid"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// sessionStore keeps sessions and the revocation list in Redis when the document cache is
+// configured, so a logout takes effect on every gateway instance, and in memory otherwise
+type sessionStore interface {
+	get(ctx context.Context, id string) (Session, error)
+	save(ctx context.Context, s Session, ttl time.Duration) error
+	// revoke deletes a session and lists its ID as revoked for ttl, the lifetime of its access tokens
+	revoke(ctx context.Context, id string, ttl time.Duration) error
+	revoked(ctx context.Context, id string) (bool, error)
+	// subjectSessions lists the sessions opened for a wallet identity that may still be live
+	subjectSessions(ctx context.Context, subject string) ([]string, error)
+}
+
+// sessionAuth issues and verifies session tokens
+type sessionAuth struct {
+	store      sessionStore
+	secret     []byte
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+	// requireTokens limits API keys to the token exchange, so only short-lived tokens reach the API
+	requireTokens bool
+}
+
+var sessions *sessionAuth
+
+type sessionContextKey struct{}
+
+// initSessions enables access tokens when AUTH_TOKEN_SECRET is set. Runs after initWallet, since
+// tokens stand in for wallet API keys, and after initDocumentCache so sessions can live in Redis.
+func initSessions() {
+	secret := getEnv("AUTH_TOKEN_SECRET", "")
+	if secret == "" {
+		log.Println("🎟️ AUTH_TOKEN_SECRET not set, access tokens disabled")
+		return
+	}
+	if len(secret) < 32 {
+		panic(fmt.Errorf("AUTH_TOKEN_SECRET must be at least 32 bytes"))
+	}
+	if callerWallet == nil {
+		panic(fmt.Errorf("access tokens require WALLET_PATH, since each session signs as a wallet identity"))
+	}
+
+	accessTTL := getEnvDuration("AUTH_ACCESS_TOKEN_TTL", 15*time.Minute)
+	refreshTTL := getEnvDuration("AUTH_REFRESH_TOKEN_TTL", 12*time.Hour)
+	if accessTTL <= 0 || refreshTTL < accessTTL {
+		panic(fmt.Errorf("AUTH_REFRESH_TOKEN_TTL (%s) must be at least AUTH_ACCESS_TOKEN_TTL (%s)", refreshTTL, accessTTL))
+	}
+
+	var store sessionStore = newMemorySessionStore()
+	backend := "memory"
+	if documentCache != nil {
+		store, backend = redisSessionStore{documentCache, refreshTTL}, "Redis"
+	}
+	sessions = &sessionAuth{
+		store:         store,
+		secret:        []byte(secret),
+		accessTTL:     accessTTL,
+		refreshTTL:    refreshTTL,
+		requireTokens: getEnvBool("AUTH_REQUIRE_TOKENS", false),
+	}
+	log.Printf("🎟️ Issuing %s access tokens for %s sessions, kept in %s", sessions.accessTTL, sessions.refreshTTL, backend)
+}
+
+// authError is an authentication failure with the code its response carries
+type authError struct {
+	code    string
+	message string
+}
+
+func (e *authError) Error() string {
+	return e.message
+}
+
+// authenticateCaller selects the signing identity for a bearer access token when sessions are
+// enabled and no API key is sent, for the API key otherwise, and for a mapped client certificate
+// when neither is sent. exchange marks the token exchange, the one route that still takes API
+// keys when tokens are required.
+func authenticateCaller(ctx context.Context, apiKey, authorization string, cert *x509.Certificate, exchange bool) (context.Context, error) {
+	if sessions != nil {
+		if token, ok := strings.CutPrefix(authorization, "Bearer "); ok && apiKey == "" {
+			claims, err := sessions.verifyAccess(ctx, token, time.Now())
+			if err != nil {
+				return ctx, err
+			}
+			id := callerWallet.identity(claims.Subject)
+			if id == nil {
+				return ctx, &authError{errCodeTokenRevoked, "The token's wallet identity is no longer loaded"}
+			}
+			return context.WithValue(withSigner(ctx, id), sessionContextKey{}, claims), nil
+		}
+		if sessions.requireTokens && apiKey != "" && !exchange {
+			return ctx, &authError{errCodeTokenRequired, "Exchange the API key for an access token at " + tokenExchangeRoute}
+		}
+	}
+
+	if apiKey == "" {
+		if rule := matchClientCert(cert); rule != nil {
+			return withClientCert(ctx, rule), nil
+		}
+	}
+	if callerWallet == nil {
+		return ctx, nil
+	}
+	id, err := callerWallet.resolve(apiKey)
+	if err != nil {
+		return ctx, err
+	}
+	if id != nil {
+		ctx = withSigner(ctx, id)
+	}
+	return ctx, nil
+}
+
+// sessionFromContext returns the claims of the access token the request authenticated with
+func sessionFromContext(ctx context.Context) *accessClaims {
+	claims, _ := ctx.Value(sessionContextKey{}).(*accessClaims)
+	return claims
+}
+
+// signAccess issues an HS256 JWT for the session
+func (a *sessionAuth) signAccess(s Session, now time.Time) (string, error) {
+	payload, err := json.Marshal(accessClaims{
+		Issuer:    accessTokenIssuer,
+		Subject:   s.Subject,
+		SessionID: s.ID,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(a.accessTTL).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`)) + "." +
+		base64.RawURLEncoding.EncodeToString(payload)
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(a.mac(unsigned)), nil
+}
+
+func (a *sessionAuth) mac(unsigned string) []byte {
+	mac := hmac.New(sha256.New, a.secret)
+	mac.Write([]byte(unsigned))
+	return mac.Sum(nil)
+}
+
+// verifyAccess checks an access token's signature, expiry and session
+func (a *sessionAuth) verifyAccess(ctx context.Context, token string, now time.Time) (*accessClaims, error) {
+	invalid := &authError{errCodeTokenInvalid, "The access token is not valid"}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, invalid
+	}
+	var header struct {
+		Algorithm string `json:"alg"`
+	}
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(headerJSON, &header) != nil || header.Algorithm != "HS256" {
+		return nil, invalid
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(signature, a.mac(parts[0]+"."+parts[1])) {
+		return nil, invalid
+	}
+	var claims accessClaims
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || json.Unmarshal(payload, &claims) != nil || claims.Issuer != accessTokenIssuer || claims.SessionID == "" {
+		return nil, invalid
+	}
+	if now.Unix() >= claims.ExpiresAt {
+		return nil, &authError{errCodeTokenExpired, "The access token has expired; refresh it"}
+	}
+
+	revoked, err := a.store.revoked(ctx, claims.SessionID)
+	if err != nil {
+		return nil, &authError{errCodeSessionsUnavailable, "Failed to check the session: " + err.Error()}
+	}
+	if revoked {
+		return nil, &authError{errCodeTokenRevoked, "The session has been logged out"}
+	}
+	return &claims, nil
+}
+
+// open starts a session for a wallet identity
+func (a *sessionAuth) open(ctx context.Context, subject, deviceID string, now time.Time) (TokenResponse, error) {
+	s := Session{
+		ID:        randomToken(16),
+		Subject:   subject,
+		DeviceID:  deviceID,
+		CreatedAt: now.UTC().Format(time.RFC3339),
+		ExpiresAt: now.Add(a.refreshTTL).UTC().Format(time.RFC3339),
+	}
+	return a.rotate(ctx, s, now)
+}
+
+// refresh swaps a refresh token for a new pair. Presenting a secret that has already been rotated
+// out means the token was copied, so the whole session is revoked.
+func (a *sessionAuth) refresh(ctx context.Context, refreshToken string, now time.Time) (TokenResponse, error) {
+	id, secret, ok := splitRefreshToken(refreshToken)
+	if !ok {
+		return TokenResponse{}, &authError{errCodeTokenInvalid, "The refresh token is not valid"}
+	}
+	s, err := a.store.get(ctx, id)
+	if errors.Is(err, errRedisNil) {
+		return TokenResponse{}, &authError{errCodeTokenRevoked, "The session has ended; log in again"}
+	}
+	if err != nil {
+		return TokenResponse{}, err
+	}
+	if subtle.ConstantTimeCompare([]byte(hashToken(secret)), []byte(s.RefreshHash)) != 1 {
+		if err := a.store.revoke(ctx, s.ID, a.accessTTL); err != nil {
+			return TokenResponse{}, err
+		}
+		log.Printf("⚠️  Refresh token reuse on session %s of %s, session revoked", s.ID, s.Subject)
+		return TokenResponse{}, &authError{errCodeRefreshReused, "The refresh token was already used; the session has been revoked"}
+	}
+	s.RefreshedAt = now.UTC().Format(time.RFC3339)
+	return a.rotate(ctx, s, now)
+}
+
+// rotate stores a new refresh secret for the session and issues an access token with it
+func (a *sessionAuth) rotate(ctx context.Context, s Session, now time.Time) (TokenResponse, error) {
+	expires, err := time.Parse(time.RFC3339, s.ExpiresAt)
+	if err != nil {
+		return TokenResponse{}, err
+	}
+	remaining := expires.Sub(now)
+	if remaining <= 0 {
+		return TokenResponse{}, &authError{errCodeTokenExpired, "The session has expired; log in again"}
+	}
+
+	secret := randomToken(32)
+	s.RefreshHash = hashToken(secret)
+	if err := a.store.save(ctx, s, remaining); err != nil {
+		return TokenResponse{}, err
+	}
+	access, err := a.signAccess(s, now)
+	if err != nil {
+		return TokenResponse{}, err
+	}
+	return TokenResponse{
+		AccessToken:      access,
+		TokenType:        "Bearer",
+		ExpiresIn:        int(a.accessTTL.Seconds()),
+		RefreshToken:     s.ID + "." + secret,
+		RefreshExpiresAt: s.ExpiresAt,
+		SessionID:        s.ID,
+	}, nil
+}
+
+// revokeSubject ends every session of a wallet identity and returns how many were live
+func (a *sessionAuth) revokeSubject(ctx context.Context, subject string) (int, error) {
+	ids, err := a.store.subjectSessions(ctx, subject)
+	if err != nil {
+		return 0, err
+	}
+	revoked := 0
+	for _, id := range ids {
+		if _, err := a.store.get(ctx, id); err == nil {
+			revoked++
+		}
+		if err := a.store.revoke(ctx, id, a.accessTTL); err != nil {
+			return revoked, err
+		}
+	}
+	return revoked, nil
+}
+
+// splitRefreshToken separates a "<session>.<secret>" refresh token
+func splitRefreshToken(token string) (string, string, bool) {
+	id, secret, ok := strings.Cut(token, ".")
+	return id, secret, ok && len(id) == 32 && len(secret) == 64
+}
+
+// randomToken returns n random bytes in hex
+func randomToken(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func hashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// redisSessionStore keeps each session and revocation under its own expiring key, with a set per
+// wallet identity for revoke-all
+type redisSessionStore struct {
+	redis  *redisClient
+	retain time.Duration
+}
+
+func (s redisSessionStore) get(ctx context.Context, id string) (Session, error) {
+	var session Session
+	data, err := s.redis.Get(ctx, sessionKeyPrefix+id)
+	if err != nil {
+		return session, err
+	}
+	return session, json.Unmarshal(data, &session)
+}
+
+func (s redisSessionStore) save(ctx context.Context, session Session, ttl time.Duration) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	if err := s.redis.Set(ctx, sessionKeyPrefix+session.ID, data, ttl); err != nil {
+		return err
+	}
+	members := subjectSessionsPrefix + session.Subject
+	if _, err := s.redis.Do(ctx, "SADD", members, session.ID); err != nil {
+		return err
+	}
+	// No session outlives the refresh TTL, so the set can expire with the newest one
+	_, err = s.redis.Do(ctx, "PEXPIRE", members, strconv.FormatInt(s.retain.Milliseconds(), 10))
+	return err
+}
+
+func (s redisSessionStore) revoke(ctx context.Context, id string, ttl time.Duration) error {
+	session, err := s.get(ctx, id)
+	if err != nil && !errors.Is(err, errRedisNil) {
+		return err
+	}
+	if err := s.redis.Set(ctx, revokedSessionKeyPrefix+id, []byte("1"), ttl); err != nil {
+		return err
+	}
+	if session.Subject != "" {
+		if _, err := s.redis.Do(ctx, "SREM", subjectSessionsPrefix+session.Subject, id); err != nil {
+			return err
+		}
+	}
+	return s.redis.Del(ctx, sessionKeyPrefix+id)
+}
+
+func (s redisSessionStore) revoked(ctx context.Context, id string) (bool, error) {
+	reply, err := s.redis.Do(ctx, "EXISTS", revokedSessionKeyPrefix+id)
+	if err != nil {
+		return false, err
+	}
+	count, _ := reply.(int64)
+	return count > 0, nil
+}
+
+func (s redisSessionStore) subjectSessions(ctx context.Context, subject string) ([]string, error) {
+	reply, err := s.redis.Do(ctx, "SMEMBERS", subjectSessionsPrefix+subject)
+	if err != nil {
+		return nil, err
+	}
+	values, _ := reply.([]interface{})
+	ids := make([]string, 0, len(values))
+	for _, value := range values {
+		if id, ok := value.([]byte); ok {
+			ids = append(ids, string(id))
+		}
+	}
+	return ids, nil
+}
+
+// memorySessionStore serves a single gateway instance
+type memorySessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]Session
+	expires  map[string]time.Time
+	// revokedUntil holds revoked session IDs until their access tokens have expired
+	revokedUntil map[string]time.Time
+}
+
+func newMemorySessionStore() *memorySessionStore {
+	return &memorySessionStore{sessions: map[string]Session{}, expires: map[string]time.Time{}, revokedUntil: map[string]time.Time{}}
+}
+
+func (s *memorySessionStore) get(_ context.Context, id string) (Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[id]
+	if !ok || time.Now().After(s.expires[id]) {
+		return Session{}, errRedisNil
+	}
+	return session, nil
+}
+
+func (s *memorySessionStore) save(_ context.Context, session Session, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for id, expires := range s.expires {
+		if now.After(expires) {
+			delete(s.sessions, id)
+			delete(s.expires, id)
+		}
+	}
+	s.sessions[session.ID] = session
+	s.expires[session.ID] = now.Add(ttl)
+	return nil
+}
+
+func (s *memorySessionStore) revoke(_ context.Context, id string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for revoked, until := range s.revokedUntil {
+		if now.After(until) {
+			delete(s.revokedUntil, revoked)
+		}
+	}
+	delete(s.sessions, id)
+	delete(s.expires, id)
+	s.revokedUntil[id] = now.Add(ttl)
+	return nil
+}
+
+func (s *memorySessionStore) revoked(_ context.Context, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	until, ok := s.revokedUntil[id]
+	return ok && time.Now().Before(until), nil
+}
+
+func (s *memorySessionStore) subjectSessions(_ context.Context, subject string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ids []string
+	for id, session := range s.sessions {
+		if session.Subject == subject {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func sessionsDisabled(c *gin.Context) bool {
+	if sessions == nil {
+		respondError(c, http.StatusServiceUnavailable, errCodeSessionsDisabled, "Access tokens are not enabled")
+		return true
+	}
+	return false
+}
+
+// respondAuthError answers with the authentication failure's code, or as a service error
+func respondAuthError(c *gin.Context, message string, err error) {
+	var authErr *authError
+	if errors.As(err, &authErr) {
+		respondError(c, http.StatusUnauthorized, authErr.code, authErr.message)
+		return
+	}
+	respondError(c, http.StatusServiceUnavailable, errCodeSessionsUnavailable, fmt.Sprintf("%s: %v", message, err))
+}
+
+// issueToken opens a session for the wallet identity of the caller's API key
+func issueToken(c *gin.Context) {
+	if sessionsDisabled(c) {
+		return
+	}
+	var req IssueTokenRequest
+	if c.Request.ContentLength != 0 && !bindRequest(c, &req) {
+		return
+	}
+
+	ctx := c.Request.Context()
+	id, ok := ctx.Value(signerContextKey{}).(*walletIdentity)
+	if !ok || id == nil || c.GetHeader(apiKeyHeader) == "" {
+		respondError(c, http.StatusUnauthorized, errCodeUnauthenticated, "Send a registered X-API-Key to open a session")
+		return
+	}
+	tokens, err := sessions.open(ctx, id.Label, req.DeviceID, time.Now())
+	if err != nil {
+		respondAuthError(c, "Failed to open session", err)
+		return
+	}
+	respondData(c, http.StatusCreated, tokens)
+}
+
+// refreshSession rotates a refresh token. It authenticates by the refresh token alone, so it is
+// served outside the signer middleware.
+func refreshSession(c *gin.Context) {
+	if sessionsDisabled(c) {
+		return
+	}
+	var req RefreshTokenRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+	tokens, err := sessions.refresh(c.Request.Context(), req.RefreshToken, time.Now())
+	if err != nil {
+		respondAuthError(c, "Failed to refresh session", err)
+		return
+	}
+	respondData(c, http.StatusOK, tokens)
+}
+
+// logout revokes the session of the access token the request carries
+func logout(c *gin.Context) {
+	if sessionsDisabled(c) {
+		return
+	}
+	claims := sessionFromContext(c.Request.Context())
+	if claims == nil {
+		respondError(c, http.StatusUnauthorized, errCodeTokenRequired, "Log out with the session's access token")
+		return
+	}
+	if err := sessions.store.revoke(c.Request.Context(), claims.SessionID, sessions.accessTTL); err != nil {
+		respondAuthError(c, "Failed to revoke session", err)
+		return
+	}
+	respondData(c, http.StatusOK, loggedOut{Message: "Logged out successfully", SessionID: claims.SessionID})
+}
+
+// revokeAllSessions ends every session of the caller's wallet identity, or of another identity
+// for callers granted delete on sessions
+func revokeAllSessions(c *gin.Context) {
+	if sessionsDisabled(c) {
+		return
+	}
+	var req RevokeSessionsRequest
+	if c.Request.ContentLength != 0 && !bindRequest(c, &req) {
+		return
+	}
+
+	ctx := c.Request.Context()
+	id, _ := ctx.Value(signerContextKey{}).(*walletIdentity)
+	subject := req.Wallet
+	if id != nil && (subject == "" || subject == id.Label) {
+		subject = id.Label
+	} else if subject == "" {
+		respondError(c, http.StatusUnauthorized, errCodeUnauthenticated, "Authenticate as the wallet identity whose sessions to revoke")
+		return
+	} else if !access.allows(ctx, "sessions", actionDelete) {
+		respondError(c, http.StatusForbidden, errCodeAccessDenied, "Your roles do not allow delete on sessions")
+		return
+	}
+	if callerWallet.identity(subject) == nil {
+		respondError(c, http.StatusNotFound, errCodeNotFound, "Wallet identity not found")
+		return
+	}
+
+	revoked, err := sessions.revokeSubject(ctx, subject)
+	if err != nil {
+		respondAuthError(c, "Failed to revoke sessions", err)
+		return
+	}
+	respondData(c, http.StatusOK, revokedSessions{Message: "Sessions revoked successfully", Wallet: subject, Revoked: revoked})
+}
@@ -0,0 +1,117 @@
+package gateway
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSessionTokens(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	sum := sha256.Sum256([]byte("shift-key"))
+	previous := callerWallet
+	callerWallet = &wallet{
+		identities: map[string]*walletIdentity{"officer-1": {Label: "officer-1"}, "officer-2": {Label: "officer-2"}},
+		callers:    map[string]string{hex.EncodeToString(sum[:]): "officer-1"},
+	}
+	sessions = &sessionAuth{
+		store:         newMemorySessionStore(),
+		secret:        []byte(strings.Repeat("s", 32)),
+		accessTTL:     15 * time.Minute,
+		refreshTTL:    12 * time.Hour,
+		requireTokens: true,
+	}
+	defer func() { callerWallet, sessions = previous, nil }()
+
+	r := gin.New()
+	r.POST("/api/v1/auth/refresh", refreshSession)
+	auth := r.Group("/api/v1/auth", signerMiddleware())
+	auth.POST("/token", issueToken)
+	auth.POST("/logout", logout)
+	auth.POST("/revoke-all", revokeAllSessions)
+	r.GET("/api/v1/whoami", signerMiddleware(), func(c *gin.Context) {
+		id, _ := c.Request.Context().Value(signerContextKey{}).(*walletIdentity)
+		respondData(c, http.StatusOK, gin.H{"label": id.Label})
+	})
+
+	send := func(method, path, body string, headers ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		for i := 0; i+1 < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	tokens := func(w *httptest.ResponseRecorder) TokenResponse {
+		var envelope struct {
+			Data TokenResponse `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &envelope)
+		return envelope.Data
+	}
+	bearer := func(token string) []string { return []string{"Authorization", "Bearer " + token} }
+
+	if w := send(http.MethodGet, "/api/v1/whoami", "", apiKeyHeader, "shift-key"); w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), errCodeTokenRequired) {
+		t.Errorf("expected the API key refused outside the token exchange, got %d: %s", w.Code, w.Body)
+	}
+	w := send(http.MethodPost, "/api/v1/auth/token", `{"device_id":"patrol-tablet-7"}`, apiKeyHeader, "shift-key")
+	first := tokens(w)
+	if w.Code != http.StatusCreated || first.AccessToken == "" || first.RefreshToken == "" {
+		t.Fatalf("expected a session opened, got %d: %s", w.Code, w.Body)
+	}
+	if w := send(http.MethodGet, "/api/v1/whoami", "", bearer(first.AccessToken)...); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "officer-1") {
+		t.Errorf("expected the token to sign as officer-1, got %d: %s", w.Code, w.Body)
+	}
+	if w := send(http.MethodGet, "/api/v1/whoami", "", bearer(first.AccessToken+"x")...); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected a tampered token rejected, got %d", w.Code)
+	}
+
+	w = send(http.MethodPost, "/api/v1/auth/refresh", `{"refresh_token":"`+first.RefreshToken+`"}`)
+	second := tokens(w)
+	if w.Code != http.StatusOK || second.SessionID != first.SessionID || second.RefreshToken == first.RefreshToken || second.RefreshExpiresAt != first.RefreshExpiresAt {
+		t.Fatalf("expected the refresh token rotated within the same session, got %d: %s", w.Code, w.Body)
+	}
+	// Replaying the rotated token looks like theft, so the whole session ends
+	if w := send(http.MethodPost, "/api/v1/auth/refresh", `{"refresh_token":"`+first.RefreshToken+`"}`); w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), errCodeRefreshReused) {
+		t.Errorf("expected a reused refresh token rejected, got %d: %s", w.Code, w.Body)
+	}
+	if w := send(http.MethodGet, "/api/v1/whoami", "", bearer(second.AccessToken)...); w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), errCodeTokenRevoked) {
+		t.Errorf("expected the session's access token revoked, got %d: %s", w.Code, w.Body)
+	}
+
+	third := tokens(send(http.MethodPost, "/api/v1/auth/token", "", apiKeyHeader, "shift-key"))
+	if w := send(http.MethodPost, "/api/v1/auth/logout", "", bearer(third.AccessToken)...); w.Code != http.StatusOK {
+		t.Fatalf("expected a logout, got %d: %s", w.Code, w.Body)
+	}
+	if w := send(http.MethodPost, "/api/v1/auth/refresh", `{"refresh_token":"`+third.RefreshToken+`"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected a logged out session not refreshed, got %d", w.Code)
+	}
+
+	tablet := tokens(send(http.MethodPost, "/api/v1/auth/token", "", apiKeyHeader, "shift-key"))
+	phone := tokens(send(http.MethodPost, "/api/v1/auth/token", "", apiKeyHeader, "shift-key"))
+	w = send(http.MethodPost, "/api/v1/auth/revoke-all", "", bearer(phone.AccessToken)...)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"revoked":2`) {
+		t.Fatalf("expected both live sessions revoked, got %d: %s", w.Code, w.Body)
+	}
+	if w := send(http.MethodGet, "/api/v1/whoami", "", bearer(tablet.AccessToken)...); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected the other device logged out, got %d", w.Code)
+	}
+
+	// Sessions end with the shift however often they are refreshed
+	s := Session{ID: randomToken(16), Subject: "officer-2", ExpiresAt: time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)}
+	if _, err := sessions.rotate(t.Context(), s, time.Now()); err == nil {
+		t.Error("expected an expired session not rotated")
+	}
+	expired, _ := sessions.signAccess(Session{ID: s.ID, Subject: "officer-2"}, time.Now().Add(-time.Hour))
+	if _, err := sessions.verifyAccess(t.Context(), expired, time.Now()); err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Errorf("expected an expired access token rejected, got %v", err)
+	}
+}
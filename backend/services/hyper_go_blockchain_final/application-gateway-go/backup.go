@@ -0,0 +1,1091 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"google.golang.org/protobuf/proto"
+)
+
+// backupCommands are the sih-backup commands
+var backupCommands = []string{"snapshot", "schedule", "verify", "restore"}
+
+const (
+	backupManifestFile = "manifest.json"
+	backupLedgerDir    = "ledger"
+	backupIndexFile    = "index.json.gz"
+	// snapshotSignableMetadata and snapshotAdditionalMetadata are written by the peer beside the
+	// snapshot's data files
+	snapshotSignableMetadata   = "_snapshot_signable_metadata.json"
+	snapshotAdditionalMetadata = "_snapshot_additional_metadata.json"
+	// defaultPeerSnapshots is the peer's ledger.snapshots.rootDir in the Fabric images
+	defaultPeerSnapshots = "/var/hyperledger/production/snapshots"
+	backupPollInterval   = 2 * time.Second
+	indexRestoreBatch    = 500
+)
+
+// indexTables are the off-chain index tables a backup dumps, checkpoints first
+var indexTables = []string{"index_checkpoints", "dids", "incidents", "sos_alerts", "evidence", "audits", "incident_archives"}
+
+// networkBackup configures sih-backup. Snapshots are generated on Peer and copied out of its
+// snapshots directory, which is read through Mount when it is on this host and otherwise with
+// docker cp from Container, by default the container named after the peer as in the test network.
+type networkBackup struct {
+	Dir       string `json:"dir"`
+	Peer      string `json:"peer"`
+	Container string `json:"container,omitempty"`
+	Mount     string `json:"mount,omitempty"`
+	PeerPath  string `json:"peerPath,omitempty"`
+	Every     string `json:"every,omitempty"`
+	Keep      int    `json:"keep,omitempty"`
+	Timeout   string `json:"timeout,omitempty"`
+}
+
+func (b *networkBackup) setDefaults() {
+	if b.Dir == "" {
+		b.Dir = "backups"
+	}
+	if b.Mount == "" && b.Container == "" {
+		b.Container = b.Peer
+	}
+	if b.PeerPath == "" {
+		b.PeerPath = defaultPeerSnapshots
+	}
+	if b.Every == "" {
+		b.Every = "24h"
+	}
+	if b.Keep == 0 {
+		b.Keep = 7
+	}
+	if b.Timeout == "" {
+		b.Timeout = "10m"
+	}
+}
+
+func (b *networkBackup) validate(t *networkTopology) error {
+	if _, _, ok := t.findPeer(b.Peer); !ok {
+		return fmt.Errorf("backup peer %q is not in the topology", b.Peer)
+	}
+	switch {
+	case b.Mount != "" && b.Container != "":
+		return errors.New("set the backup mount or container, not both")
+	case !path.IsAbs(b.PeerPath):
+		return fmt.Errorf("backup peerPath %q must be absolute", b.PeerPath)
+	case b.Keep < 1:
+		return errors.New("backup keep must be at least 1")
+	}
+	for name, value := range map[string]string{"every": b.Every, "timeout": b.Timeout} {
+		if d, err := time.ParseDuration(value); err != nil || d <= 0 {
+			return fmt.Errorf("backup %s %q must be a positive duration", name, value)
+		}
+	}
+	return nil
+}
+
+func (b *networkBackup) every() time.Duration {
+	d, _ := time.ParseDuration(b.Every)
+	return d
+}
+
+func (b *networkBackup) timeout() time.Duration {
+	d, _ := time.ParseDuration(b.Timeout)
+	return d
+}
+
+// findPeer looks a peer up by name
+func (t *networkTopology) findPeer(name string) (networkPeerOrg, networkPeer, bool) {
+	for _, org := range t.Orgs {
+		for _, p := range org.Peers {
+			if p.Name == name {
+				return org, p, true
+			}
+		}
+	}
+	return networkPeerOrg{}, networkPeer{}, false
+}
+
+// dialTopologyPeer connects to a peer of the topology as its organization's admin
+func dialTopologyPeer(t *networkTopology, name string) (*lifecyclePeer, error) {
+	org, p, ok := t.findPeer(name)
+	if !ok {
+		return nil, fmt.Errorf("peer %q is not in the topology", name)
+	}
+	admin, err := loadNetworkAdmin(t, org)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := dialPeer(t, org, p)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", p.Name, err)
+	}
+	return &lifecyclePeer{org: org, peer: p, admin: admin, conn: conn}, nil
+}
+
+// peerFiles copies directories in and out of a peer's file system, addressed by the paths the
+// peer itself uses
+type peerFiles interface {
+	copyOut(ctx context.Context, peerDir, localDir string) error
+	copyIn(ctx context.Context, localDir, peerDir string) error
+	remove(ctx context.Context, peerDir string) error
+}
+
+// mountedFiles reaches the peer's snapshots directory, PeerPath inside the peer, at Mount here
+type mountedFiles struct {
+	mount    string
+	peerPath string
+}
+
+func (m mountedFiles) local(peerDir string) (string, error) {
+	rel, err := filepath.Rel(m.peerPath, peerDir)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is outside the mounted %s", peerDir, m.peerPath)
+	}
+	return filepath.Join(m.mount, rel), nil
+}
+
+func (m mountedFiles) copyOut(_ context.Context, peerDir, localDir string) error {
+	src, err := m.local(peerDir)
+	if err != nil {
+		return err
+	}
+	return copyDir(src, localDir)
+}
+
+func (m mountedFiles) copyIn(_ context.Context, localDir, peerDir string) error {
+	dst, err := m.local(peerDir)
+	if err != nil {
+		return err
+	}
+	return copyDir(localDir, dst)
+}
+
+func (m mountedFiles) remove(_ context.Context, peerDir string) error {
+	dir, err := m.local(peerDir)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+// containerFiles reaches the peer's file system with the docker CLI
+type containerFiles struct {
+	container string
+}
+
+func (c containerFiles) docker(ctx context.Context, args ...string) error {
+	out, err := exec.CommandContext(ctx, "docker", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("docker %s: %w: %s", args[0], err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func (c containerFiles) copyOut(ctx context.Context, peerDir, localDir string) error {
+	if err := os.MkdirAll(filepath.Dir(localDir), 0o755); err != nil {
+		return err
+	}
+	return c.docker(ctx, "cp", c.container+":"+peerDir+"/.", localDir)
+}
+
+func (c containerFiles) copyIn(ctx context.Context, localDir, peerDir string) error {
+	if err := c.docker(ctx, "exec", c.container, "mkdir", "-p", path.Dir(peerDir)); err != nil {
+		return err
+	}
+	return c.docker(ctx, "cp", localDir+"/.", c.container+":"+peerDir)
+}
+
+func (c containerFiles) remove(ctx context.Context, peerDir string) error {
+	return c.docker(ctx, "exec", c.container, "rm", "-rf", peerDir)
+}
+
+func newPeerFiles(mount, container, peerPath string) peerFiles {
+	if mount != "" {
+		return mountedFiles{mount: mount, peerPath: peerPath}
+	}
+	return containerFiles{container: container}
+}
+
+// copyDir copies the regular files and directories under src to dst
+func copyDir(src, dst string) error {
+	return filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(src, p)
+		target := filepath.Join(dst, rel)
+		if d.IsDir() {
+			return os.MkdirAll(target, 0o755)
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		in, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, in); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	})
+}
+
+// snapshotMetadata is the signable part of a snapshot's metadata, which the peer hashes into the
+// snapshot hash; file hashes are hex SHA-256 of each data file
+type snapshotMetadata struct {
+	ChannelName       string            `json:"channel_name"`
+	LastBlockNumber   uint64            `json:"last_block_number"`
+	LastBlockHash     string            `json:"last_block_hash"`
+	PreviousBlockHash string            `json:"previous_block_hash"`
+	FileHashes        map[string]string `json:"snapshot_files_raw_hashes"`
+	StateDBType       string            `json:"state_db_type"`
+}
+
+type snapshotHashes struct {
+	SnapshotHash        string `json:"snapshot_hash"`
+	LastBlockCommitHash string `json:"last_block_commit_hash"`
+}
+
+// backupManifest describes a backup directory: the ledger snapshot under ledger/ and, when the
+// off-chain index was configured, its dump in index.json.gz
+type backupManifest struct {
+	Channel      string       `json:"channel"`
+	BlockNumber  uint64       `json:"block_number"`
+	BlockHash    string       `json:"block_hash"`
+	SnapshotHash string       `json:"snapshot_hash"`
+	Peer         string       `json:"peer"`
+	CreatedAt    string       `json:"created_at"`
+	Index        *backupIndex `json:"index,omitempty"`
+}
+
+// backupIndex records the dump's digest and the block the indexer had applied when it was taken
+type backupIndex struct {
+	SHA256     string         `json:"sha256"`
+	Checkpoint uint64         `json:"checkpoint"`
+	Rows       map[string]int `json:"rows"`
+}
+
+// indexDump is every row of the off-chain index read in one snapshot of the database. Values are
+// PostgreSQL text, nil for NULL, so the rows load back without knowing the column types.
+type indexDump struct {
+	TakenAt string           `json:"taken_at"`
+	Tables  []indexDumpTable `json:"tables"`
+}
+
+type indexDumpTable struct {
+	Name    string      `json:"name"`
+	Columns []string    `json:"columns"`
+	Rows    [][]*string `json:"rows"`
+}
+
+// dumpIndex reads the index tables. It must run first in a transaction, which it makes a
+// read-only repeatable read so every table reflects the same checkpoint.
+func dumpIndex(ctx context.Context, q pgQuerier) (*indexDump, error) {
+	if _, err := q.Exec(ctx, `SET TRANSACTION ISOLATION LEVEL REPEATABLE READ, READ ONLY`); err != nil {
+		return nil, err
+	}
+	dump := &indexDump{TakenAt: time.Now().UTC().Format(time.RFC3339)}
+	for _, table := range indexTables {
+		rows, err := q.Query(ctx, `SELECT column_name FROM information_schema.columns
+			WHERE table_schema = current_schema() AND table_name = $1 ORDER BY ordinal_position`, table)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the columns of %s: %w", table, err)
+		}
+		t := indexDumpTable{Name: table, Rows: [][]*string{}}
+		order := make([]string, len(rows))
+		for i, row := range rows {
+			t.Columns = append(t.Columns, row.String(0))
+			order[i] = strconv.Itoa(i + 1)
+		}
+		if len(t.Columns) == 0 {
+			return nil, fmt.Errorf("table %s does not exist", table)
+		}
+		data, err := q.Query(ctx, fmt.Sprintf(`SELECT %s FROM %s ORDER BY %s`, quoteColumns(t.Columns), table, strings.Join(order, ", ")))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", table, err)
+		}
+		for _, row := range data {
+			t.Rows = append(t.Rows, row)
+		}
+		dump.Tables = append(dump.Tables, t)
+	}
+	return dump, nil
+}
+
+func quoteColumns(columns []string) string {
+	quoted := make([]string, len(columns))
+	for i, c := range columns {
+		quoted[i] = `"` + strings.ReplaceAll(c, `"`, `""`) + `"`
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// loadIndex inserts a dump's rows, indexRestoreBatch at a time
+func loadIndex(ctx context.Context, q pgQuerier, dump *indexDump) error {
+	for _, t := range dump.Tables {
+		if !slices.Contains(indexTables, t.Name) {
+			return fmt.Errorf("the dump has an unknown table %s", t.Name)
+		}
+		for start := 0; start < len(t.Rows); start += indexRestoreBatch {
+			batch := t.Rows[start:min(start+indexRestoreBatch, len(t.Rows))]
+			var values []string
+			var args []interface{}
+			for _, row := range batch {
+				if len(row) != len(t.Columns) {
+					return fmt.Errorf("a row of %s has %d columns, not %d", t.Name, len(row), len(t.Columns))
+				}
+				placeholders := make([]string, len(row))
+				for i, value := range row {
+					args = append(args, value)
+					placeholders[i] = "$" + strconv.Itoa(len(args))
+				}
+				values = append(values, "("+strings.Join(placeholders, ", ")+")")
+			}
+			query := fmt.Sprintf(`INSERT INTO %s (%s) VALUES %s`, t.Name, quoteColumns(t.Columns), strings.Join(values, ", "))
+			if _, err := q.Exec(ctx, query, args...); err != nil {
+				return fmt.Errorf("failed to restore %s: %w", t.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// blockCheckpointPrefix matches the sih-indexer checkpoints of a channel's chaincodes
+func blockCheckpointPrefix(channel string) string {
+	return "blocks:" + channel + "/"
+}
+
+// checkpoint returns the lowest block the sih-indexer has applied on the channel. Only block
+// checkpoints count: the gateway's event checkpoint resumes from a block the restored peer may not
+// have, so a backup needs the indexer.
+func (d *indexDump) checkpoint(channel string) (uint64, bool) {
+	var lowest uint64
+	found := false
+	for _, t := range d.Tables {
+		if t.Name != "index_checkpoints" {
+			continue
+		}
+		name, block := slices.Index(t.Columns, "name"), slices.Index(t.Columns, "block_number")
+		if name < 0 || block < 0 {
+			return 0, false
+		}
+		for _, row := range t.Rows {
+			if row[name] == nil || row[block] == nil || !strings.HasPrefix(*row[name], blockCheckpointPrefix(channel)) {
+				continue
+			}
+			n, err := strconv.ParseUint(*row[block], 10, 64)
+			if err != nil {
+				return 0, false
+			}
+			if !found || n < lowest {
+				lowest, found = n, true
+			}
+		}
+	}
+	return lowest, found
+}
+
+func (d *indexDump) rowCounts() map[string]int {
+	counts := map[string]int{}
+	for _, t := range d.Tables {
+		counts[t.Name] = len(t.Rows)
+	}
+	return counts
+}
+
+// writeIndexDump writes the dump gzipped and returns its hex SHA-256
+func writeIndexDump(filename string, dump *indexDump) (string, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(gz).Encode(dump); err != nil {
+		return "", err
+	}
+	if err := gz.Close(); err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	return hex.EncodeToString(sum[:]), os.WriteFile(filename, buf.Bytes(), 0o600)
+}
+
+// readIndexDump reads a dump, failing unless its digest is want
+func readIndexDump(filename, want string) (*indexDump, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != want {
+		return nil, errors.New("the index dump does not match its digest")
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	var dump indexDump
+	if err := json.NewDecoder(gz).Decode(&dump); err != nil {
+		return nil, fmt.Errorf("invalid index dump: %w", err)
+	}
+	return &dump, nil
+}
+
+func readJSONFile(filename string, v interface{}) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("invalid %s: %w", filepath.Base(filename), err)
+	}
+	return nil
+}
+
+func writeJSONFile(filename string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, append(data, '\n'), 0o644)
+}
+
+func fileDigest(filename string) (string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	digest := sha256.New()
+	if _, err := io.Copy(digest, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(digest.Sum(nil)), nil
+}
+
+// verifyBackup checks a backup directory on its own: the snapshot files against the hashes the
+// peer recorded, the snapshot hash against the signable metadata, the index dump against its
+// digest, and that the index had applied at least the snapshot's last block, so an indexer
+// restored from the dump can follow a peer joined from the snapshot without a gap
+func verifyBackup(dir string) (*backupManifest, error) {
+	var manifest backupManifest
+	if err := readJSONFile(filepath.Join(dir, backupManifestFile), &manifest); err != nil {
+		return nil, err
+	}
+	ledger := filepath.Join(dir, backupLedgerDir)
+	signable, err := os.ReadFile(filepath.Join(ledger, snapshotSignableMetadata))
+	if err != nil {
+		return nil, err
+	}
+	var metadata snapshotMetadata
+	if err := json.Unmarshal(signable, &metadata); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", snapshotSignableMetadata, err)
+	}
+	var hashes snapshotHashes
+	if err := readJSONFile(filepath.Join(ledger, snapshotAdditionalMetadata), &hashes); err != nil {
+		return nil, err
+	}
+
+	var problems []error
+	if metadata.ChannelName != manifest.Channel || metadata.LastBlockNumber != manifest.BlockNumber || metadata.LastBlockHash != manifest.BlockHash {
+		problems = append(problems, fmt.Errorf("the snapshot is of %s block %d, the manifest says %s block %d",
+			metadata.ChannelName, metadata.LastBlockNumber, manifest.Channel, manifest.BlockNumber))
+	}
+	if sum := sha256.Sum256(signable); hex.EncodeToString(sum[:]) != hashes.SnapshotHash || hashes.SnapshotHash != manifest.SnapshotHash {
+		problems = append(problems, errors.New("the snapshot hash does not match its metadata"))
+	}
+	if len(metadata.FileHashes) == 0 {
+		problems = append(problems, errors.New("the snapshot lists no files"))
+	}
+	names := make([]string, 0, len(metadata.FileHashes))
+	for name := range metadata.FileHashes {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		if name != filepath.Base(name) {
+			problems = append(problems, fmt.Errorf("the snapshot lists an invalid file %q", name))
+			continue
+		}
+		digest, err := fileDigest(filepath.Join(ledger, name))
+		if err != nil {
+			problems = append(problems, err)
+		} else if digest != metadata.FileHashes[name] {
+			problems = append(problems, fmt.Errorf("%s does not match its hash", name))
+		}
+	}
+
+	if manifest.Index != nil {
+		dump, err := readIndexDump(filepath.Join(dir, backupIndexFile), manifest.Index.SHA256)
+		if err != nil {
+			problems = append(problems, err)
+		} else {
+			checkpoint, ok := dump.checkpoint(manifest.Channel)
+			switch {
+			case !ok:
+				problems = append(problems, errors.New("the index dump has no sih-indexer checkpoint for the channel"))
+			case checkpoint != manifest.Index.Checkpoint:
+				problems = append(problems, fmt.Errorf("the index dump is at block %d, the manifest says %d", checkpoint, manifest.Index.Checkpoint))
+			case checkpoint < manifest.BlockNumber:
+				problems = append(problems, fmt.Errorf("the index dump is at block %d, behind the snapshot at %d", checkpoint, manifest.BlockNumber))
+			}
+			for table, count := range dump.rowCounts() {
+				if manifest.Index.Rows[table] != count {
+					problems = append(problems, fmt.Errorf("the index dump has %d rows of %s, the manifest says %d", count, table, manifest.Index.Rows[table]))
+				}
+			}
+		}
+	}
+	return &manifest, errors.Join(problems...)
+}
+
+// backupNameRegex matches the directories backupName names
+var backupNameRegex = regexp.MustCompile(`^(.+)-(\d{12})$`)
+
+// backupName names a backup directory by channel and zero-padded block, so they sort by height
+func backupName(channel string, block uint64) string {
+	return fmt.Sprintf("%s-%012d", channel, block)
+}
+
+// listBackups returns the channel's complete backups, newest first
+func listBackups(dir, channel string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		m := backupNameRegex.FindStringSubmatch(e.Name())
+		if !e.IsDir() || m == nil || m[1] != channel {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(dir, e.Name(), backupManifestFile)); err == nil {
+			names = append(names, e.Name())
+		}
+	}
+	slices.Sort(names)
+	slices.Reverse(names)
+	return names, nil
+}
+
+// pruneBackups removes all but the newest keep backups of the channel
+func pruneBackups(dir, channel string, keep int) error {
+	names, err := listBackups(dir, channel)
+	if err != nil || len(names) <= keep {
+		return err
+	}
+	for _, name := range names[keep:] {
+		if err := os.RemoveAll(filepath.Join(dir, name)); err != nil {
+			return err
+		}
+		log.Printf("🧹 Removed backup %s", name)
+	}
+	return nil
+}
+
+// RunBackup is the sih-backup entry point
+func RunBackup() {
+	flags := flag.NewFlagSet("sih-backup", flag.ExitOnError)
+	topologyFile := flags.String("topology", getEnv("NETWORK_TOPOLOGY", "../test-network/topology.json"), "topology file with the backup section")
+	indexURL := flags.String("index-url", getEnv("INDEX_DATABASE_URL", ""), "off-chain index to dump with each snapshot; without it only the ledger is backed up")
+	every := flags.Duration("every", 0, "interval between scheduled backups, overriding the topology")
+	keep := flags.Int("keep", 0, "backups to keep, overriding the topology")
+	ledger := flags.Bool("ledger", false, "verify also checks the snapshot's last block against the ledger on the backup peer")
+	intoPeer := flags.String("into-peer", "", "restore joins this topology peer to the channel from the snapshot")
+	intoContainer := flags.String("into-container", "", "container of the restored peer, by default named after it")
+	intoMount := flags.String("into-mount", "", "host directory mounted as the restored peer's snapshots directory")
+	intoIndex := flags.String("into-index", "", "restore loads the index dump into this empty database")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: sih-backup [flags] <snapshot|schedule>\n")
+		fmt.Fprintf(flags.Output(), "       sih-backup [flags] <verify|restore> [backup directory, by default the newest]\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(os.Args[1:])
+	command := flags.Arg(0)
+	if !slices.Contains(backupCommands, command) || flags.NArg() > 2 || (flags.NArg() == 2 && command != "verify" && command != "restore") {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	topology, err := loadNetworkTopology(*topologyFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if topology.Backup == nil {
+		log.Fatalf("%s has no backup section", *topologyFile)
+	}
+	config := topology.Backup
+	if *every > 0 {
+		config.Every = every.String()
+	}
+	if *keep > 0 {
+		config.Keep = *keep
+	}
+	b := &backupRunner{topology: topology, config: config, dir: topology.path(config.Dir), files: newPeerFiles(topology.path(config.Mount), config.Container, config.PeerPath)}
+	if *indexURL != "" {
+		if b.index, err = newPGClient(*indexURL, 2, getEnvDuration("INDEX_TIMEOUT", time.Minute)); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	switch command {
+	case "snapshot":
+		_, err = b.snapshot(ctx)
+	case "schedule":
+		b.schedule(ctx)
+	case "verify", "restore":
+		dir := flags.Arg(1)
+		if dir == "" {
+			if dir, err = b.latest(); err != nil {
+				break
+			}
+		}
+		if command == "verify" {
+			err = b.verify(ctx, dir, *ledger)
+			break
+		}
+		if *intoPeer == "" && *intoIndex == "" {
+			log.Fatal("restore needs -into-peer, -into-index or both")
+		}
+		err = b.restore(ctx, dir, restoreTarget{peer: *intoPeer, container: cmp.Or(*intoContainer, *intoPeer), mount: *intoMount, index: *intoIndex})
+	}
+	if err != nil {
+		stop()
+		log.Fatalf("❌ %s failed: %v", command, err)
+	}
+}
+
+// backupRunner takes, verifies and restores the backups of one channel
+type backupRunner struct {
+	topology *networkTopology
+	config   *networkBackup
+	dir      string
+	files    peerFiles
+	// index is the off-chain index, nil when only the ledger is backed up
+	index *pgClient
+}
+
+// latest returns the newest backup of the channel
+func (b *backupRunner) latest() (string, error) {
+	names, err := listBackups(b.dir, b.topology.Channel)
+	if err != nil {
+		return "", err
+	}
+	if len(names) == 0 {
+		return "", fmt.Errorf("no backups of %s in %s", b.topology.Channel, b.dir)
+	}
+	return filepath.Join(b.dir, names[0]), nil
+}
+
+// schedule takes a backup now and then every config.Every, keeping the newest config.Keep. A
+// failed backup is logged and the schedule goes on.
+func (b *backupRunner) schedule(ctx context.Context) {
+	log.Printf("🗓️  Backing up %s from %s every %s, keeping %d", b.topology.Channel, b.config.Peer, b.config.Every, b.config.Keep)
+	ticker := time.NewTicker(b.config.every())
+	defer ticker.Stop()
+	for {
+		if _, err := b.snapshot(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("❌ Backup failed: %v", err)
+		} else if err := pruneBackups(b.dir, b.topology.Channel, b.config.Keep); err != nil {
+			log.Printf("⚠️  Failed to remove old backups: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// snapshot has the backup peer snapshot its last committed block, copies the snapshot out, dumps
+// the index once it has caught up with that block and verifies the result before publishing it
+// under its final name. A block already backed up is not backed up again.
+func (b *backupRunner) snapshot(ctx context.Context) (string, error) {
+	channel := b.topology.Channel
+	p, err := dialTopologyPeer(b.topology, b.config.Peer)
+	if err != nil {
+		return "", err
+	}
+	defer p.conn.Close()
+	ctx, cancel := context.WithTimeout(ctx, b.config.timeout())
+	defer cancel()
+
+	block, err := b.requestSnapshot(ctx, p)
+	if err != nil {
+		return "", err
+	}
+	final := filepath.Join(b.dir, backupName(channel, block))
+	if _, err := os.Stat(filepath.Join(final, backupManifestFile)); err == nil {
+		log.Printf("⏭️  Block %d of %s is already backed up in %s", block, channel, final)
+		return final, nil
+	}
+	if err := b.waitSnapshot(ctx, p, block); err != nil {
+		return "", err
+	}
+
+	partial := filepath.Join(b.dir, "."+backupName(channel, block)+".partial")
+	if err := os.RemoveAll(partial); err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(partial)
+	peerDir := path.Join(b.config.PeerPath, "completed", channel, strconv.FormatUint(block, 10))
+	if err := b.files.copyOut(ctx, peerDir, filepath.Join(partial, backupLedgerDir)); err != nil {
+		return "", fmt.Errorf("failed to collect the snapshot: %w", err)
+	}
+	var metadata snapshotMetadata
+	if err := readJSONFile(filepath.Join(partial, backupLedgerDir, snapshotSignableMetadata), &metadata); err != nil {
+		return "", err
+	}
+	var hashes snapshotHashes
+	if err := readJSONFile(filepath.Join(partial, backupLedgerDir, snapshotAdditionalMetadata), &hashes); err != nil {
+		return "", err
+	}
+	if err := checkLedgerHash(ctx, p, channel, block, metadata.LastBlockHash); err != nil {
+		return "", err
+	}
+	manifest := backupManifest{
+		Channel:      channel,
+		BlockNumber:  block,
+		BlockHash:    metadata.LastBlockHash,
+		SnapshotHash: hashes.SnapshotHash,
+		Peer:         p.peer.Name,
+		CreatedAt:    time.Now().UTC().Format(time.RFC3339),
+	}
+
+	if b.index != nil {
+		if manifest.Index, err = b.dumpIndex(ctx, block, filepath.Join(partial, backupIndexFile)); err != nil {
+			return "", err
+		}
+	}
+	if err := writeJSONFile(filepath.Join(partial, backupManifestFile), manifest); err != nil {
+		return "", err
+	}
+	if _, err := verifyBackup(partial); err != nil {
+		return "", fmt.Errorf("the collected backup does not verify: %w", err)
+	}
+	if err := os.Rename(partial, final); err != nil {
+		return "", err
+	}
+	// The peer keeps every snapshot until it is deleted, so it goes once it is safely copied
+	if err := b.files.remove(ctx, peerDir); err != nil {
+		log.Printf("⚠️  Failed to remove the snapshot from %s: %v", p.peer.Name, err)
+	}
+	log.Printf("💾 Backed up %s at block %d to %s", channel, block, final)
+	return final, nil
+}
+
+// requestSnapshot asks the peer to snapshot its last committed block and returns the block. A
+// block committed between reading the height and the request makes the peer refuse it, so the
+// height is read again.
+func (b *backupRunner) requestSnapshot(ctx context.Context, p *lifecyclePeer) (uint64, error) {
+	var err error
+	for attempt := 0; attempt < 3; attempt++ {
+		var info *common.BlockchainInfo
+		if info, err = chainInfo(ctx, p, b.topology.Channel); err != nil {
+			return 0, err
+		}
+		if info.GetHeight() < 2 {
+			return 0, errors.New("the channel has only its genesis block")
+		}
+		block := info.GetHeight() - 1
+		if _, statErr := os.Stat(filepath.Join(b.dir, backupName(b.topology.Channel, block), backupManifestFile)); statErr == nil {
+			return block, nil
+		}
+		request, signErr := signedSnapshotRequest(p.admin, &peer.SnapshotRequest{ChannelId: b.topology.Channel, BlockNumber: block})
+		if signErr != nil {
+			return 0, signErr
+		}
+		if _, err = peer.NewSnapshotClient(p.conn).Generate(ctx, request); err == nil {
+			log.Printf("📸 Requested a snapshot of %s at block %d from %s", b.topology.Channel, block, p.peer.Name)
+			return block, nil
+		}
+	}
+	return 0, fmt.Errorf("the snapshot request failed: %w", err)
+}
+
+// waitSnapshot polls the peer until the snapshot is no longer pending
+func (b *backupRunner) waitSnapshot(ctx context.Context, p *lifecyclePeer, block uint64) error {
+	for {
+		query, err := signedSnapshotRequest(p.admin, &peer.SnapshotQuery{ChannelId: b.topology.Channel})
+		if err != nil {
+			return err
+		}
+		pending, err := peer.NewSnapshotClient(p.conn).QueryPendings(ctx, query)
+		if err != nil {
+			return fmt.Errorf("failed to query pending snapshots: %w", err)
+		}
+		if !slices.Contains(pending.GetBlockNumbers(), block) {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("the snapshot at block %d is still pending: %w", block, ctx.Err())
+		case <-time.After(backupPollInterval):
+		}
+	}
+}
+
+// dumpIndex waits for the sih-indexer to apply the snapshot's block, then writes the dump
+func (b *backupRunner) dumpIndex(ctx context.Context, block uint64, filename string) (*backupIndex, error) {
+	for {
+		rows, err := b.index.Query(ctx, `SELECT min(block_number) FROM index_checkpoints WHERE name LIKE $1`, blockCheckpointPrefix(b.topology.Channel)+"%")
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the index checkpoint: %w", err)
+		}
+		if len(rows) == 0 || rows[0][0] == nil {
+			return nil, fmt.Errorf("the index has no sih-indexer checkpoint for %s, back up the ledger only by leaving out -index-url", b.topology.Channel)
+		}
+		if uint64(rows[0].Int(0)) >= block {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("the sih-indexer has not reached block %d: %w", block, ctx.Err())
+		case <-time.After(backupPollInterval):
+		}
+	}
+
+	var dump *indexDump
+	err := b.index.Tx(ctx, func(q pgQuerier) error {
+		var err error
+		dump, err = dumpIndex(ctx, q)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	checkpoint, _ := dump.checkpoint(b.topology.Channel)
+	digest, err := writeIndexDump(filename, dump)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("🗂️  Dumped the off-chain index at block %d", checkpoint)
+	return &backupIndex{SHA256: digest, Checkpoint: checkpoint, Rows: dump.rowCounts()}, nil
+}
+
+// verify checks a backup, and with ledger also that the backup peer's block matches the snapshot
+func (b *backupRunner) verify(ctx context.Context, dir string, ledger bool) error {
+	manifest, err := verifyBackup(dir)
+	if err != nil {
+		return err
+	}
+	if ledger {
+		p, err := dialTopologyPeer(b.topology, b.config.Peer)
+		if err != nil {
+			return err
+		}
+		defer p.conn.Close()
+		if err := checkLedgerHash(ctx, p, manifest.Channel, manifest.BlockNumber, manifest.BlockHash); err != nil {
+			return err
+		}
+	}
+	summary := "ledger only"
+	if manifest.Index != nil {
+		summary = fmt.Sprintf("index at block %d", manifest.Index.Checkpoint)
+	}
+	log.Printf("✅ %s: %s block %d, %s", dir, manifest.Channel, manifest.BlockNumber, summary)
+	return nil
+}
+
+// restoreTarget is where a disaster recovery drill restores a backup to
+type restoreTarget struct {
+	peer      string
+	container string
+	mount     string
+	index     string
+}
+
+// restore verifies the backup, loads the index dump into an empty database and joins a peer that
+// is not yet on the channel from the snapshot. An sih-indexer pointed at both then resumes after
+// the dump's checkpoint.
+func (b *backupRunner) restore(ctx context.Context, dir string, target restoreTarget) error {
+	manifest, err := verifyBackup(dir)
+	if err != nil {
+		return fmt.Errorf("%s does not verify: %w", dir, err)
+	}
+	if target.index != "" {
+		if manifest.Index == nil {
+			return errors.New("the backup has no index dump")
+		}
+		if err := restoreIndex(ctx, dir, manifest, target.index); err != nil {
+			return err
+		}
+	}
+	if target.peer != "" {
+		if err := b.joinBySnapshot(ctx, dir, manifest, target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func restoreIndex(ctx context.Context, dir string, manifest *backupManifest, rawURL string) error {
+	dump, err := readIndexDump(filepath.Join(dir, backupIndexFile), manifest.Index.SHA256)
+	if err != nil {
+		return err
+	}
+	db, err := newPGClient(rawURL, 1, getEnvDuration("INDEX_TIMEOUT", time.Minute))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if err := db.ExecScript(ctx, indexSchema); err != nil {
+		return fmt.Errorf("failed to migrate the restored index: %w", err)
+	}
+	err = db.Tx(ctx, func(q pgQuerier) error {
+		rows, err := q.Query(ctx, `SELECT count(*) FROM index_checkpoints`)
+		if err != nil {
+			return err
+		}
+		if rows[0].Int(0) > 0 {
+			return errors.New("the database already has an index, restore into an empty one")
+		}
+		return loadIndex(ctx, q, dump)
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("🗂️  Restored the off-chain index at block %d to %s", manifest.Index.Checkpoint, db.addr)
+	return nil
+}
+
+// joinBySnapshot copies the snapshot into the peer and joins it to the channel from it, waiting
+// until the peer has built its ledger
+func (b *backupRunner) joinBySnapshot(ctx context.Context, dir string, manifest *backupManifest, target restoreTarget) error {
+	p, err := dialTopologyPeer(b.topology, target.peer)
+	if err != nil {
+		return err
+	}
+	defer p.conn.Close()
+	channels, err := peerChannels(ctx, p.conn, p.admin)
+	if err != nil {
+		return err
+	}
+	if slices.Contains(channels, manifest.Channel) {
+		return fmt.Errorf("%s is already on %s", p.peer.Name, manifest.Channel)
+	}
+
+	peerDir := path.Join(b.config.PeerPath, "imported", backupName(manifest.Channel, manifest.BlockNumber))
+	if err := newPeerFiles(target.mount, target.container, b.config.PeerPath).copyIn(ctx, filepath.Join(dir, backupLedgerDir), peerDir); err != nil {
+		return fmt.Errorf("failed to copy the snapshot to %s: %w", p.peer.Name, err)
+	}
+	if _, err := invokeCSCC(ctx, p.conn, p.admin, []byte("JoinChainBySnapshot"), []byte(peerDir)); err != nil {
+		return err
+	}
+	log.Printf("🔗 %s is joining %s from the snapshot at block %d", p.peer.Name, manifest.Channel, manifest.BlockNumber)
+
+	ctx, cancel := context.WithTimeout(ctx, b.config.timeout())
+	defer cancel()
+	for {
+		payload, err := invokeCSCC(ctx, p.conn, p.admin, []byte("JoinBySnapshotStatus"))
+		if err != nil {
+			return err
+		}
+		var status peer.JoinBySnapshotStatus
+		if err := proto.Unmarshal(payload, &status); err != nil {
+			return err
+		}
+		if !status.GetInProgress() {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s is still joining: %w", p.peer.Name, ctx.Err())
+		case <-time.After(backupPollInterval):
+		}
+	}
+	info, err := chainInfo(ctx, p, manifest.Channel)
+	if err != nil {
+		return err
+	}
+	if info.GetHeight() <= manifest.BlockNumber {
+		return fmt.Errorf("%s is at height %d, below the snapshot", p.peer.Name, info.GetHeight())
+	}
+	log.Printf("✅ %s joined %s at height %d", p.peer.Name, manifest.Channel, info.GetHeight())
+	return nil
+}
+
+// signedSnapshotRequest signs a snapshot request or query as the organization admin
+func signedSnapshotRequest(admin *networkAdmin, request proto.Message) (*peer.SignedSnapshotRequest, error) {
+	nonce := make([]byte, 24)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	header := &common.SignatureHeader{Creator: admin.creator, Nonce: nonce}
+	switch r := request.(type) {
+	case *peer.SnapshotRequest:
+		r.SignatureHeader = header
+	case *peer.SnapshotQuery:
+		r.SignatureHeader = header
+	}
+	data, err := proto.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := admin.signDigest(data)
+	if err != nil {
+		return nil, err
+	}
+	return &peer.SignedSnapshotRequest{Request: data, Signature: signature}, nil
+}
+
+// chainInfo reads the channel's height and last block hash from the peer's qscc
+func chainInfo(ctx context.Context, p *lifecyclePeer, channel string) (*common.BlockchainInfo, error) {
+	payload, err := invokeSystemChaincode(ctx, p.conn, p.admin, "", qsccName, []byte("GetChainInfo"), []byte(channel))
+	if err != nil {
+		return nil, err
+	}
+	var info common.BlockchainInfo
+	return &info, proto.Unmarshal(payload, &info)
+}
+
+// checkLedgerHash fails unless the peer's block has the hash the snapshot recorded
+func checkLedgerHash(ctx context.Context, p *lifecyclePeer, channel string, number uint64, want string) error {
+	payload, err := invokeSystemChaincode(ctx, p.conn, p.admin, "", qsccName, []byte("GetBlockByNumber"), []byte(channel), []byte(strconv.FormatUint(number, 10)))
+	if err != nil {
+		return err
+	}
+	var block common.Block
+	if err := proto.Unmarshal(payload, &block); err != nil {
+		return err
+	}
+	if got := hex.EncodeToString(blockHeaderHash(block.GetHeader())); got != want {
+		return fmt.Errorf("block %d on %s has hash %s, the snapshot recorded %s", number, p.peer.Name, got, want)
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+package gateway
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// fakeIndexQuerier serves the index tables from memory and records what is executed
+type fakeIndexQuerier struct {
+	columns map[string][]string
+	rows    map[string][]pgRow
+	execs   []string
+	args    [][]interface{}
+}
+
+func (f *fakeIndexQuerier) Exec(_ context.Context, query string, args ...interface{}) (int64, error) {
+	f.execs = append(f.execs, query)
+	f.args = append(f.args, args)
+	return 1, nil
+}
+
+func (f *fakeIndexQuerier) Query(_ context.Context, query string, args ...interface{}) ([]pgRow, error) {
+	if strings.Contains(query, "information_schema") {
+		var rows []pgRow
+		for _, c := range f.columns[args[0].(string)] {
+			rows = append(rows, pgRow{&c})
+		}
+		return rows, nil
+	}
+	fields := strings.Fields(query[strings.Index(query, " FROM "):])
+	return f.rows[fields[1]], nil
+}
+
+func textRow(values ...string) pgRow {
+	row := make(pgRow, len(values))
+	for i, v := range values {
+		if v != "NULL" {
+			row[i] = &v
+		}
+	}
+	return row
+}
+
+func testIndexDump(t *testing.T, checkpoint string) *indexDump {
+	t.Helper()
+	q := &fakeIndexQuerier{
+		columns: map[string][]string{"index_checkpoints": {"name", "block_number", "tx_id", "updated_at"}},
+		rows: map[string][]pgRow{
+			"index_checkpoints": {
+				textRow("blocks:mychannel/sihcc", checkpoint, "tx9", "2026-10-16 08:00:00+00"),
+				textRow("mychannel/sihcc", "3", "tx3", "2026-10-16 07:00:00+00"),
+			},
+			"dids": {textRow("did:sih:t1", "hash", "issuer", "NULL")},
+		},
+	}
+	for _, table := range indexTables[1:] {
+		q.columns[table] = []string{"id", "hash", "issuer", "expires_at"}
+	}
+	dump, err := dumpIndex(context.Background(), q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(q.execs[0], "SET TRANSACTION ISOLATION LEVEL REPEATABLE READ") {
+		t.Errorf("expected the dump to read one snapshot of the database, got %v", q.execs)
+	}
+	return dump
+}
+
+func TestIndexDump(t *testing.T) {
+	dump := testIndexDump(t, "12")
+	if len(dump.Tables) != len(indexTables) || dump.Tables[1].Rows[0][3] != nil {
+		t.Fatalf("expected every table with NULLs kept, got %+v", dump.Tables)
+	}
+	if block, ok := dump.checkpoint("mychannel"); !ok || block != 12 {
+		t.Errorf("expected the sih-indexer checkpoint, got %d, %v", block, ok)
+	}
+	if _, ok := dump.checkpoint("other"); ok {
+		t.Error("expected no checkpoint for another channel")
+	}
+
+	filename := filepath.Join(t.TempDir(), backupIndexFile)
+	digest, err := writeIndexDump(filename, dump)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, err := readIndexDump(filename, digest)
+	if err != nil || read.rowCounts()["index_checkpoints"] != 2 {
+		t.Fatalf("expected the dump to read back, got %v", err)
+	}
+	if _, err := readIndexDump(filename, strings.Repeat("0", 64)); err == nil {
+		t.Error("expected a dump with another digest to be refused")
+	}
+
+	q := &fakeIndexQuerier{}
+	for range indexRestoreBatch + 1 {
+		read.Tables[1].Rows = append(read.Tables[1].Rows, []*string{nil, nil, nil, &read.TakenAt})
+	}
+	if err := loadIndex(context.Background(), q, read); err != nil {
+		t.Fatal(err)
+	}
+	// The checkpoints, then the DIDs in two batches
+	if len(q.execs) != 3 || !strings.HasPrefix(q.execs[0], `INSERT INTO index_checkpoints ("name", "block_number", "tx_id", "updated_at") VALUES ($1, $2, $3, $4), ($5,`) {
+		t.Fatalf("unexpected inserts %v", q.execs)
+	}
+	if len(q.args[1]) != 4*indexRestoreBatch || len(q.args[2]) != 2*4 || q.args[1][3] != (*string)(nil) {
+		t.Errorf("expected batches of %d rows with NULLs as nil, got %d and %d arguments", indexRestoreBatch, len(q.args[1]), len(q.args[2]))
+	}
+
+	read.Tables[0].Name = "pg_authid"
+	if err := loadIndex(context.Background(), &fakeIndexQuerier{}, read); err == nil {
+		t.Error("expected a table outside the index to be refused")
+	}
+}
+
+// writeTestBackup lays out a backup of block 10 of mychannel as sih-backup collects it
+func writeTestBackup(t *testing.T, dir string, dump *indexDump) {
+	t.Helper()
+	ledger := filepath.Join(dir, backupLedgerDir)
+	os.MkdirAll(ledger, 0o755)
+	hashes := map[string]string{}
+	for name, content := range map[string]string{"public_state.data": "state", "public_state.metadata": "meta", "txids.data": "txids"} {
+		os.WriteFile(filepath.Join(ledger, name), []byte(content), 0o644)
+		sum := sha256.Sum256([]byte(content))
+		hashes[name] = hex.EncodeToString(sum[:])
+	}
+	writeJSONFile(filepath.Join(ledger, snapshotSignableMetadata), snapshotMetadata{ChannelName: "mychannel", LastBlockNumber: 10, LastBlockHash: "ab12", FileHashes: hashes, StateDBType: "CouchDB"})
+	signable, _ := os.ReadFile(filepath.Join(ledger, snapshotSignableMetadata))
+	sum := sha256.Sum256(signable)
+	writeJSONFile(filepath.Join(ledger, snapshotAdditionalMetadata), snapshotHashes{SnapshotHash: hex.EncodeToString(sum[:])})
+
+	manifest := backupManifest{Channel: "mychannel", BlockNumber: 10, BlockHash: "ab12", SnapshotHash: hex.EncodeToString(sum[:]), Peer: "peer0.org1.example.com"}
+	if dump != nil {
+		digest, err := writeIndexDump(filepath.Join(dir, backupIndexFile), dump)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkpoint, _ := dump.checkpoint("mychannel")
+		manifest.Index = &backupIndex{SHA256: digest, Checkpoint: checkpoint, Rows: dump.rowCounts()}
+	}
+	writeJSONFile(filepath.Join(dir, backupManifestFile), manifest)
+}
+
+func TestVerifyBackup(t *testing.T) {
+	root := t.TempDir()
+	good := filepath.Join(root, "good")
+	writeTestBackup(t, good, testIndexDump(t, "12"))
+	manifest, err := verifyBackup(good)
+	if err != nil {
+		t.Fatalf("expected the backup to verify, got %v", err)
+	}
+	if manifest.BlockNumber != 10 || manifest.Index.Checkpoint != 12 {
+		t.Errorf("unexpected manifest %+v", manifest)
+	}
+
+	ledgerOnly := filepath.Join(root, "ledger-only")
+	writeTestBackup(t, ledgerOnly, nil)
+	if _, err := verifyBackup(ledgerOnly); err != nil {
+		t.Errorf("expected a backup without an index to verify, got %v", err)
+	}
+
+	behind := filepath.Join(root, "behind")
+	writeTestBackup(t, behind, testIndexDump(t, "9"))
+	if _, err := verifyBackup(behind); err == nil || !strings.Contains(err.Error(), "behind the snapshot at 10") {
+		t.Errorf("expected an index behind the snapshot to be refused, got %v", err)
+	}
+
+	os.WriteFile(filepath.Join(good, backupLedgerDir, "txids.data"), []byte("tampered"), 0o644)
+	os.WriteFile(filepath.Join(good, backupIndexFile), []byte("tampered"), 0o644)
+	_, err = verifyBackup(good)
+	if err == nil || !strings.Contains(err.Error(), "txids.data does not match its hash") || !strings.Contains(err.Error(), "index dump does not match its digest") {
+		t.Errorf("expected both tampered files reported, got %v", err)
+	}
+}
+
+func TestBackupFiles(t *testing.T) {
+	topology, err := loadNetworkTopology("../test-network/topology.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	backup := topology.Backup
+	if backup.Container != backup.Peer || backup.PeerPath != defaultPeerSnapshots || backup.Timeout != "10m" || backup.every().Hours() != 6 {
+		t.Errorf("unexpected backup defaults %+v", backup)
+	}
+	for name, edit := range map[string]func(*networkBackup){
+		"peer":  func(b *networkBackup) { b.Peer = "peer9.example.com" },
+		"mount": func(b *networkBackup) { b.Mount = "/mnt/peer0" },
+		"path":  func(b *networkBackup) { b.PeerPath = "snapshots" },
+		"every": func(b *networkBackup) { b.Every = "daily" },
+	} {
+		broken := *backup
+		edit(&broken)
+		if err := broken.validate(topology); err == nil {
+			t.Errorf("expected the %s change to be refused", name)
+		}
+	}
+
+	mount := t.TempDir()
+	files := newPeerFiles(mount, "", defaultPeerSnapshots)
+	completed := filepath.Join(mount, "completed", "mychannel", "10")
+	writeTestBackup(t, completed, nil)
+	local := filepath.Join(t.TempDir(), "copy")
+	if err := files.copyOut(context.Background(), defaultPeerSnapshots+"/completed/mychannel/10", local); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := verifyBackup(local); err != nil {
+		t.Errorf("expected the copy to verify, got %v", err)
+	}
+	if err := files.copyOut(context.Background(), "/etc", local); err == nil {
+		t.Error("expected a path outside the snapshots directory to be refused")
+	}
+	files.remove(context.Background(), defaultPeerSnapshots+"/completed/mychannel/10")
+	if _, err := os.Stat(completed); !os.IsNotExist(err) {
+		t.Errorf("expected the peer's copy removed, got %v", err)
+	}
+}
+
+func TestPruneBackups(t *testing.T) {
+	dir := t.TempDir()
+	for _, block := range []uint64{9, 120, 35, 1000} {
+		writeTestBackup(t, filepath.Join(dir, backupName("mychannel", block)), nil)
+	}
+	os.MkdirAll(filepath.Join(dir, backupName("other", 1)), 0o755)
+	os.MkdirAll(filepath.Join(dir, "."+backupName("mychannel", 2000)+".partial"), 0o755)
+
+	if err := pruneBackups(dir, "mychannel", 2); err != nil {
+		t.Fatal(err)
+	}
+	names, _ := listBackups(dir, "mychannel")
+	if !slices.Equal(names, []string{backupName("mychannel", 1000), backupName("mychannel", 120)}) {
+		t.Errorf("expected the two highest blocks kept, got %v", names)
+	}
+	if _, err := os.Stat(filepath.Join(dir, backupName("other", 1))); err != nil {
+		t.Error("expected another channel's backups left alone")
+	}
+}
@@ -0,0 +1,417 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"github.com/hyperledger/fabric-protos-go-apiv2/ledger/rwset"
+	"github.com/hyperledger/fabric-protos-go-apiv2/ledger/rwset/kvrwset"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"google.golang.org/protobuf/proto"
+)
+
+// blockProgressInterval is how many blocks pass between progress lines while catching up
+const blockProgressInterval = 1000
+
+// ledgerWrite is one key a valid transaction wrote to the chaincode's namespace
+type ledgerWrite struct {
+	TxID    string
+	Key     string
+	Value   []byte
+	Deleted bool
+}
+
+// blockIndexer projects the chaincode's writes from full blocks into the off-chain index. Unlike
+// the gateway's event consumer it sees every write, including audit entries that raise no event,
+// so nothing is read back from the ledger.
+type blockIndexer struct {
+	db             *pgClient
+	target         *fabricTarget
+	checkpointName string
+	// bridge republishes chaincode events to Kafka when KAFKA_BROKERS is set
+	bridge *kafkaBridge
+}
+
+func newBlockIndexer(db *pgClient, target *fabricTarget) *blockIndexer {
+	return &blockIndexer{db: db, target: target, checkpointName: blockCheckpointName(target)}
+}
+
+// blockCheckpointName keeps the block checkpoint apart from the event consumer's
+func blockCheckpointName(target *fabricTarget) string {
+	return "blocks:" + target.Channel + "/" + target.Chaincode
+}
+
+// RunIndexer is the entry point of the sih-indexer binary. It shares the gateway's Fabric and
+// INDEX_* configuration.
+func RunIndexer() {
+	reindex := flag.Bool("reindex", false, "empty the projections and replay the ledger from block 0")
+	flag.Parse()
+
+	rawURL := getEnv("INDEX_DATABASE_URL", "")
+	if rawURL == "" {
+		log.Fatal("sih-indexer needs INDEX_DATABASE_URL")
+	}
+	initFabricConnection()
+	defer closeFabricConnection()
+	indexer := newBlockIndexer(openIndexDatabase(rawURL), defaultTarget)
+	indexer.bridge = newKafkaBridgeFromEnv()
+	if indexer.bridge != nil {
+		defer indexer.bridge.producer.close()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *reindex {
+		if err := indexer.reset(ctx); err != nil {
+			log.Fatalf("Failed to reset the off-chain index: %v", err)
+		}
+		log.Printf("🗂️  Off-chain index emptied, re-indexing %s from block 0", indexer.checkpointName)
+	}
+	indexer.run(ctx)
+}
+
+// reset empties the projections and the block checkpoint in one transaction, so the next run
+// replays the ledger from genesis
+func (bx *blockIndexer) reset(ctx context.Context) error {
+	return bx.db.Tx(ctx, func(q pgQuerier) error {
+		if _, err := q.Exec(ctx, `TRUNCATE dids, incidents, sos_alerts, evidence, audits, incident_archives`); err != nil {
+			return err
+		}
+		_, err := q.Exec(ctx, `DELETE FROM index_checkpoints WHERE name = $1`, bx.checkpointName)
+		return err
+	})
+}
+
+// nextBlock returns the block after the last one applied, or 0 to start from genesis
+func (bx *blockIndexer) nextBlock(ctx context.Context) (uint64, error) {
+	rows, err := bx.db.Query(ctx, `SELECT block_number FROM index_checkpoints WHERE name = $1`, bx.checkpointName)
+	if err != nil || len(rows) == 0 {
+		return 0, err
+	}
+	return uint64(rows[0].Int(0)) + 1, nil
+}
+
+// run tails blocks until ctx is cancelled, resuming from the stored checkpoint whenever the stream
+// ends so no block is skipped or applied twice
+func (bx *blockIndexer) run(ctx context.Context) {
+	backoff := time.Second
+	for ctx.Err() == nil {
+		err := bx.consume(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("🗂️  Block indexer stopped, retrying in %s: %v", backoff, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, time.Minute)
+	}
+}
+
+func (bx *blockIndexer) consume(ctx context.Context) error {
+	next, err := bx.nextBlock(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load checkpoint: %w", err)
+	}
+
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	blocks, err := bx.target.network.BlockEvents(streamCtx, client.WithStartBlock(next))
+	if err != nil {
+		return err
+	}
+	log.Printf("🗂️  Block indexer consuming %s from block %d", bx.checkpointName, next)
+
+	for block := range blocks {
+		number := block.GetHeader().GetNumber()
+		if err := bx.apply(ctx, block); err != nil {
+			return fmt.Errorf("failed to index block %d: %w", number, err)
+		}
+		if number%blockProgressInterval == 0 {
+			log.Printf("🗂️  Indexed block %d", number)
+		}
+	}
+	return errors.New("block stream closed")
+}
+
+// apply projects every write in the block and advances the checkpoint in a single database
+// transaction. The block's events are published to Kafka first, so a crash between the two
+// publishes them again rather than losing them.
+func (bx *blockIndexer) apply(ctx context.Context, block *common.Block) error {
+	transactions, err := decodeBlockTransactions(block, bx.target.Chaincode)
+	if err != nil {
+		return err
+	}
+	number := block.GetHeader().GetNumber()
+	if bx.bridge != nil {
+		if err := bx.bridge.publish(ctx, bx.target, number, transactions); err != nil {
+			return fmt.Errorf("failed to publish to Kafka: %w", err)
+		}
+	}
+	return bx.db.Tx(ctx, func(q pgQuerier) error {
+		lastTxID := ""
+		for _, tx := range transactions {
+			for _, write := range projectedWrites(tx) {
+				if err := projectWrite(ctx, q, write, number); err != nil {
+					return fmt.Errorf("failed to project %s from %s: %w", write.Key, write.TxID, err)
+				}
+			}
+			lastTxID = tx.TxID
+		}
+		_, err := q.Exec(ctx, `INSERT INTO index_checkpoints (name, block_number, tx_id, updated_at) VALUES ($1, $2, $3, now())
+			ON CONFLICT (name) DO UPDATE SET block_number = EXCLUDED.block_number, tx_id = EXCLUDED.tx_id, updated_at = now()`,
+			bx.checkpointName, number, lastTxID)
+		return err
+	})
+}
+
+// blockTransaction is what a valid transaction did to the chaincode's namespace
+type blockTransaction struct {
+	TxID      string
+	Timestamp time.Time
+	Writes    []ledgerWrite
+	Events    []*peer.ChaincodeEvent
+}
+
+// decodeBlockTransactions returns the transactions that wrote to chaincode's namespace or raised
+// its events, in block order. Invalid transactions are skipped because the peers discarded their
+// writes; configuration transactions carry no read/write set.
+func decodeBlockTransactions(block *common.Block, chaincode string) ([]blockTransaction, error) {
+	var transactions []blockTransaction
+	for i, data := range block.GetData().GetData() {
+		if txValidationCode(block, i) != peer.TxValidationCode_VALID {
+			continue
+		}
+		var envelope common.Envelope
+		if err := proto.Unmarshal(data, &envelope); err != nil {
+			return nil, &malformedDocumentError{kind: "envelope", err: err}
+		}
+		var payload common.Payload
+		if err := proto.Unmarshal(envelope.GetPayload(), &payload); err != nil {
+			return nil, &malformedDocumentError{kind: "payload", err: err}
+		}
+		var channelHeader common.ChannelHeader
+		if err := proto.Unmarshal(payload.GetHeader().GetChannelHeader(), &channelHeader); err != nil {
+			return nil, &malformedDocumentError{kind: "channel header", err: err}
+		}
+		if channelHeader.GetType() != int32(common.HeaderType_ENDORSER_TRANSACTION) {
+			continue
+		}
+
+		tx := blockTransaction{TxID: channelHeader.GetTxId(), Timestamp: channelHeader.GetTimestamp().AsTime()}
+		sets, events, err := decodeTransactionActions(payload.GetData(), chaincode)
+		if err != nil {
+			return nil, err
+		}
+		for _, set := range sets {
+			for _, write := range set.GetWrites() {
+				tx.Writes = append(tx.Writes, ledgerWrite{TxID: tx.TxID, Key: write.GetKey(), Value: write.GetValue(), Deleted: write.GetIsDelete()})
+			}
+		}
+		tx.Events = events
+		if len(tx.Writes) > 0 || len(tx.Events) > 0 {
+			transactions = append(transactions, tx)
+		}
+	}
+	return transactions, nil
+}
+
+// decodeTransactionActions returns the read/write sets and chaincode events that the actions of an
+// endorser transaction recorded for chaincode
+func decodeTransactionActions(data []byte, chaincode string) ([]*kvrwset.KVRWSet, []*peer.ChaincodeEvent, error) {
+	var transaction peer.Transaction
+	if err := proto.Unmarshal(data, &transaction); err != nil {
+		return nil, nil, &malformedDocumentError{kind: "transaction", err: err}
+	}
+
+	var sets []*kvrwset.KVRWSet
+	var events []*peer.ChaincodeEvent
+	for _, action := range transaction.GetActions() {
+		var actionPayload peer.ChaincodeActionPayload
+		if err := proto.Unmarshal(action.GetPayload(), &actionPayload); err != nil {
+			return nil, nil, &malformedDocumentError{kind: "chaincode action payload", err: err}
+		}
+		var responsePayload peer.ProposalResponsePayload
+		if err := proto.Unmarshal(actionPayload.GetAction().GetProposalResponsePayload(), &responsePayload); err != nil {
+			return nil, nil, &malformedDocumentError{kind: "proposal response payload", err: err}
+		}
+		var chaincodeAction peer.ChaincodeAction
+		if err := proto.Unmarshal(responsePayload.GetExtension(), &chaincodeAction); err != nil {
+			return nil, nil, &malformedDocumentError{kind: "chaincode action", err: err}
+		}
+		var txRWSet rwset.TxReadWriteSet
+		if err := proto.Unmarshal(chaincodeAction.GetResults(), &txRWSet); err != nil {
+			return nil, nil, &malformedDocumentError{kind: "read/write set", err: err}
+		}
+		for _, ns := range txRWSet.GetNsRwset() {
+			if ns.GetNamespace() != chaincode {
+				continue
+			}
+			var set kvrwset.KVRWSet
+			if err := proto.Unmarshal(ns.GetRwset(), &set); err != nil {
+				return nil, nil, &malformedDocumentError{kind: "key/value read/write set", err: err}
+			}
+			sets = append(sets, &set)
+		}
+		if len(chaincodeAction.GetEvents()) > 0 {
+			var event peer.ChaincodeEvent
+			if err := proto.Unmarshal(chaincodeAction.GetEvents(), &event); err != nil {
+				return nil, nil, &malformedDocumentError{kind: "chaincode event", err: err}
+			}
+			if event.GetChaincodeId() == chaincode && event.GetEventName() != "" {
+				events = append(events, &event)
+			}
+		}
+	}
+	return sets, events, nil
+}
+
+// projectedWrites leaves out the writes that only move documents from raw IDs to namespaced keys:
+// those of a key migration, which copies documents unchanged, and the deletion of a raw ID that
+// the same transaction wrote under its namespaced key
+func projectedWrites(tx blockTransaction) []ledgerWrite {
+	migration := slices.ContainsFunc(tx.Events, func(e *peer.ChaincodeEvent) bool {
+		return e.GetEventName() == "MigrateLegacyDocuments"
+	})
+	moved := map[string]bool{}
+	for _, write := range tx.Writes {
+		if _, id, ok := splitDocumentKey(write.Key); ok && !write.Deleted {
+			moved[id] = true
+		}
+	}
+	writes := make([]ledgerWrite, 0, len(tx.Writes))
+	for _, write := range tx.Writes {
+		_, _, namespaced := splitDocumentKey(write.Key)
+		switch {
+		case migration && (write.Deleted || documentType(write.Value) != "audit"):
+		case write.Deleted && !namespaced && moved[write.Key]:
+		default:
+			writes = append(writes, write)
+		}
+	}
+	return writes
+}
+
+// documentTables maps document types to the delete event whose table they are projected into
+var documentTables = map[string]string{"did": "DeleteDID", "incident": "DeleteIncident", "evidence": "DeleteEvidence"}
+
+// splitDocumentKey splits a namespaced document key, which the chaincode builds as the composite
+// key "\x00<doc_type>\x00<id>\x00"
+func splitDocumentKey(key string) (string, string, bool) {
+	if !strings.HasPrefix(key, "\x00") || !strings.HasSuffix(key, "\x00") {
+		return "", "", false
+	}
+	parts := strings.Split(key[1:len(key)-1], "\x00")
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+func documentType(value []byte) string {
+	var doc struct {
+		DocType string `json:"doc_type"`
+	}
+	if json.Unmarshal(value, &doc) != nil {
+		return ""
+	}
+	return doc.DocType
+}
+
+// projectWrite applies one write to the table of its document type. A deleted namespaced key is
+// tombstoned in its type's table; a deleted raw ID, written by contracts before keys were
+// namespaced, wherever it was projected, as those IDs were unique across types. Values that are
+// not projected documents, such as FIR counters, are ignored.
+func projectWrite(ctx context.Context, q pgQuerier, write ledgerWrite, block uint64) error {
+	pos := indexPosition{txID: write.TxID, block: block}
+	if write.Deleted {
+		if docType, id, ok := splitDocumentKey(write.Key); ok {
+			event, projected := documentTables[docType]
+			if !projected {
+				return nil
+			}
+			return tombstone(ctx, q, deleteTargets[event].table, deleteTargets[event].column, id, pos)
+		}
+		for _, target := range deleteTargets {
+			if err := tombstone(ctx, q, target.table, target.column, write.Key, pos); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	switch documentType(write.Value) {
+	case "did":
+		did, err := decodeDocument[DIDDocument](write.Value, "DID")
+		if err != nil {
+			return err
+		}
+		return upsertDID(ctx, q, did, pos)
+
+	case "incident":
+		incident, err := decodeDocument[IncidentDocument](write.Value, "incident")
+		if err != nil {
+			return err
+		}
+		return upsertIncident(ctx, q, incident, pos)
+
+	case "sos":
+		sos, err := decodeDocument[SOSDocument](write.Value, "SOS")
+		if err != nil {
+			return err
+		}
+		return insertSOS(ctx, q, sos, pos)
+
+	case "evidence":
+		evidence, err := decodeDocument[EvidenceDocument](write.Value, "evidence")
+		if err != nil {
+			return err
+		}
+		return upsertEvidence(ctx, q, evidence, pos)
+
+	case "assignment":
+		assignment, err := decodeDocument[AssignmentDocument](write.Value, "assignment")
+		if err != nil {
+			return err
+		}
+		return upsertAssignment(ctx, q, assignment, pos)
+
+	case "incident_archive":
+		archive, err := decodeDocument[IncidentArchive](write.Value, "incident archive")
+		if err != nil {
+			return err
+		}
+		return archiveIncident(ctx, q, archive, pos)
+
+	case "audit":
+		audit, err := decodeDocument[AuditDocument](write.Value, "audit")
+		if err != nil {
+			return err
+		}
+		if audit.TxID == "" {
+			audit.TxID = write.TxID
+		}
+		return insertAudit(ctx, q, audit)
+	}
+	return nil
+}
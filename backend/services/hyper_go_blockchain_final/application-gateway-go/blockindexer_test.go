@@ -0,0 +1,149 @@
+package gateway
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"github.com/hyperledger/fabric-protos-go-apiv2/ledger/rwset"
+	"github.com/hyperledger/fabric-protos-go-apiv2/ledger/rwset/kvrwset"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+)
+
+// endorserEnvelope builds an endorser transaction whose single action wrote writes to each namespace
+// and raised event
+func endorserEnvelope(t *testing.T, txID string, writes map[string][]*kvrwset.KVWrite, event *peer.ChaincodeEvent) []byte {
+	var txRWSet rwset.TxReadWriteSet
+	for namespace, kvWrites := range writes {
+		set := &kvrwset.KVRWSet{Reads: []*kvrwset.KVRead{{Key: "read_only"}}, Writes: kvWrites}
+		txRWSet.NsRwset = append(txRWSet.NsRwset, &rwset.NsReadWriteSet{Namespace: namespace, Rwset: mustMarshal(t, set)})
+	}
+	action := &peer.ChaincodeAction{Results: mustMarshal(t, &txRWSet)}
+	if event != nil {
+		action.Events = mustMarshal(t, event)
+	}
+	response := &peer.ProposalResponsePayload{Extension: mustMarshal(t, action)}
+	actionPayload := &peer.ChaincodeActionPayload{Action: &peer.ChaincodeEndorsedAction{ProposalResponsePayload: mustMarshal(t, response)}}
+	transaction := &peer.Transaction{Actions: []*peer.TransactionAction{{Payload: mustMarshal(t, actionPayload)}}}
+
+	header := &common.ChannelHeader{Type: int32(common.HeaderType_ENDORSER_TRANSACTION), TxId: txID}
+	payload := &common.Payload{Header: &common.Header{ChannelHeader: mustMarshal(t, header)}, Data: mustMarshal(t, transaction)}
+	return mustMarshal(t, &common.Envelope{Payload: mustMarshal(t, payload)})
+}
+
+func TestDecodeBlockTransactions(t *testing.T) {
+	valid := endorserEnvelope(t, "tx1", map[string][]*kvrwset.KVWrite{
+		"sihcc": {{Key: "inc_1", Value: []byte(`{"doc_type":"incident","incident_id":"inc_1"}`)}, {Key: "ev_1", IsDelete: true}},
+		"lscc":  {{Key: "sihcc", Value: []byte("definition")}},
+	}, &peer.ChaincodeEvent{ChaincodeId: "sihcc", TxId: "tx1", EventName: "CreateIncident", Payload: []byte(`{"incident_id":"inc_1"}`)})
+	invalid := endorserEnvelope(t, "tx2", map[string][]*kvrwset.KVWrite{"sihcc": {{Key: "inc_2", Value: []byte(`{}`)}}}, nil)
+	config := mustMarshal(t, &common.Envelope{Payload: mustMarshal(t, &common.Payload{Header: &common.Header{
+		ChannelHeader: mustMarshal(t, &common.ChannelHeader{Type: int32(common.HeaderType_CONFIG)})}})})
+
+	filter := []byte{byte(peer.TxValidationCode_VALID), byte(peer.TxValidationCode_MVCC_READ_CONFLICT), byte(peer.TxValidationCode_VALID)}
+	block := &common.Block{
+		Header:   &common.BlockHeader{Number: 9},
+		Data:     &common.BlockData{Data: [][]byte{valid, invalid, config}},
+		Metadata: &common.BlockMetadata{Metadata: [][]byte{nil, nil, filter}},
+	}
+
+	transactions, err := decodeBlockTransactions(block, "sihcc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transactions) != 1 || transactions[0].TxID != "tx1" {
+		t.Fatalf("expected only the valid transaction, got %+v", transactions)
+	}
+	writes := transactions[0].Writes
+	if len(writes) != 2 || writes[0].TxID != "tx1" || writes[0].Key != "inc_1" || !writes[1].Deleted {
+		t.Fatalf("expected the two writes of the valid transaction to sihcc, got %+v", writes)
+	}
+	if events := transactions[0].Events; len(events) != 1 || events[0].GetEventName() != "CreateIncident" {
+		t.Errorf("expected the transaction's event, got %v", events)
+	}
+
+	block.Data.Data[0] = []byte("not an envelope")
+	if _, err := decodeBlockTransactions(block, "sihcc"); err == nil {
+		t.Error("expected an undecodable transaction to stop the indexer")
+	}
+}
+
+func TestProjectWrite(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name      string
+		write     ledgerWrite
+		wantQuery string
+		wantArg   interface{}
+	}{
+		{"incident", ledgerWrite{TxID: "tx1", Key: "inc_1", Value: []byte(`{"doc_type":"incident","incident_id":"inc_1","created_at":"2025-09-20T13:19:10Z","status":"open"}`)}, "INSERT INTO incidents", "inc_1"},
+		{"audit without tx", ledgerWrite{TxID: "tx1", Key: "audit_inc_1", Value: []byte(`{"doc_type":"audit","target_id":"inc_1","action":"CREATE_INCIDENT","timestamp":"2025-09-20T13:19:10Z"}`)}, "INSERT INTO audits", "tx1"},
+		{"counter", ledgerWrite{TxID: "tx1", Key: "FIRSEQ", Value: []byte(`{"next":4}`)}, "", nil},
+		{"binary", ledgerWrite{TxID: "tx1", Key: "blob", Value: []byte{0xff}}, "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := &recordingQuerier{}
+			if err := projectWrite(ctx, q, tt.write, 9); err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantQuery == "" {
+				if len(q.queries) != 0 {
+					t.Fatalf("expected no statements, got %v", q.queries)
+				}
+				return
+			}
+			if len(q.queries) != 1 || !strings.Contains(q.queries[0], tt.wantQuery) {
+				t.Fatalf("expected %q, got %v", tt.wantQuery, q.queries)
+			}
+			found := false
+			for _, arg := range q.args[0] {
+				found = found || arg == tt.wantArg
+			}
+			if !found {
+				t.Errorf("expected argument %v in %v", tt.wantArg, q.args[0])
+			}
+		})
+	}
+
+	q := &recordingQuerier{}
+	if err := projectWrite(ctx, q, ledgerWrite{TxID: "tx2", Key: "ev_1", Deleted: true}, 10); err != nil {
+		t.Fatal(err)
+	}
+	if len(q.queries) != len(deleteTargets) || !strings.HasPrefix(q.queries[0], "UPDATE") || q.args[0][0] != "ev_1" {
+		t.Errorf("expected the deleted key tombstoned in every document table, got %v", q.queries)
+	}
+}
+
+func TestProjectNamespacedWrites(t *testing.T) {
+	ctx := context.Background()
+	q := &recordingQuerier{}
+	if err := projectWrite(ctx, q, ledgerWrite{TxID: "tx3", Key: "\x00evidence\x00ev_1\x00", Deleted: true}, 11); err != nil {
+		t.Fatal(err)
+	}
+	if len(q.queries) != 1 || !strings.Contains(q.queries[0], "UPDATE evidence") || q.args[0][0] != "ev_1" {
+		t.Errorf("expected the evidence alone tombstoned, got %v", q.queries)
+	}
+
+	// Updating a document stored under its raw ID moves it; the raw ID's deletion is not projected
+	incident := []byte(`{"doc_type":"incident","incident_id":"inc_1"}`)
+	update := blockTransaction{TxID: "tx4", Writes: []ledgerWrite{
+		{Key: "\x00incident\x00inc_1\x00", Value: incident},
+		{Key: "inc_1", Deleted: true},
+		{Key: "ev_2", Deleted: true},
+	}}
+	if writes := projectedWrites(update); len(writes) != 2 || writes[0].Deleted || writes[1].Key != "ev_2" {
+		t.Errorf("expected the move's deletion left out, got %+v", writes)
+	}
+
+	// A key migration projects its audit entry only
+	migration := blockTransaction{TxID: "tx5", Events: []*peer.ChaincodeEvent{{EventName: "MigrateLegacyDocuments"}}, Writes: []ledgerWrite{
+		{Key: "\x00incident\x00inc_2\x00", Value: incident},
+		{Key: "\x00audit\x00audit_tx5\x00", Value: []byte(`{"doc_type":"audit"}`)},
+		{Key: "inc_2", Deleted: true},
+	}}
+	if writes := projectedWrites(migration); len(writes) != 1 || !strings.Contains(writes[0].Key, "audit") {
+		t.Errorf("expected only the audit entry projected, got %+v", writes)
+	}
+}
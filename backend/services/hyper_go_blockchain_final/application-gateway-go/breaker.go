@@ -0,0 +1,144 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Circuit breaker states
+const (
+	breakerClosed   = "CLOSED"
+	breakerOpen     = "OPEN"
+	breakerHalfOpen = "HALF_OPEN"
+)
+
+// errCircuitOpen is returned without calling Fabric while the breaker is open
+var errCircuitOpen = errors.New("fabric circuit breaker is open")
+
+// fabricBreaker guards every chaincode call made by the gateway
+var fabricBreaker = newCircuitBreaker(5, 30*time.Second)
+
+// circuitBreaker opens after threshold consecutive peer failures, rejects calls for cooldown, then
+// lets a single trial call through to decide whether to close again
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown, state: breakerClosed}
+}
+
+// initCircuitBreaker applies CIRCUIT_BREAKER_* settings to the Fabric breaker
+func initCircuitBreaker() {
+	fabricBreaker = newCircuitBreaker(
+		getEnvInt("CIRCUIT_BREAKER_THRESHOLD", 5),
+		getEnvDuration("CIRCUIT_BREAKER_COOLDOWN", 30*time.Second),
+	)
+}
+
+// allow reports whether a call may proceed, moving an expired open breaker to half-open
+func (b *circuitBreaker) allow(now time.Time) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if now.Sub(b.openedAt) < b.cooldown {
+			return errCircuitOpen
+		}
+		b.state = breakerHalfOpen
+		b.probing = true
+		log.Println("🔌 Fabric circuit breaker half-open, sending trial request")
+		return nil
+	case breakerHalfOpen:
+		if b.probing {
+			return errCircuitOpen
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+// record feeds the outcome of an allowed call back into the breaker
+func (b *circuitBreaker) record(err error, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	if errors.Is(err, context.Canceled) || status.Code(err) == codes.Canceled {
+		// The caller went away, which says nothing about the peer
+		return
+	}
+	if !isPeerFailure(err) {
+		if b.state != breakerClosed {
+			log.Println("✅ Fabric circuit breaker closed")
+		}
+		b.state = breakerClosed
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.threshold {
+		if b.state != breakerOpen {
+			log.Printf("⛔ Fabric circuit breaker open after %d consecutive peer failures: %v", b.failures, err)
+		}
+		b.state = breakerOpen
+		b.openedAt = now
+	}
+}
+
+// retryAfter is how long until the breaker will next admit a trial call
+func (b *circuitBreaker) retryAfter(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state != breakerOpen {
+		return 0
+	}
+	if remaining := b.cooldown - now.Sub(b.openedAt); remaining > 0 {
+		return remaining
+	}
+	return 0
+}
+
+func (b *circuitBreaker) currentState() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// isPeerFailure separates an unreachable or unresponsive network from ordinary chaincode errors,
+// which must not trip the breaker
+func isPeerFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,54 @@
+package gateway
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	breaker := newCircuitBreaker(2, 10*time.Second)
+	now := time.Now()
+	unavailable := status.Error(codes.Unavailable, "connection refused")
+
+	// Chaincode errors do not count towards opening the breaker
+	breaker.record(errors.New("DID did-1 does not exist"), now)
+	breaker.record(unavailable, now)
+	if got := breaker.currentState(); got != breakerClosed {
+		t.Fatalf("state after one peer failure = %s, want %s", got, breakerClosed)
+	}
+
+	breaker.record(unavailable, now)
+	if got := breaker.currentState(); got != breakerOpen {
+		t.Fatalf("state after threshold = %s, want %s", got, breakerOpen)
+	}
+	if err := breaker.allow(now.Add(time.Second)); !errors.Is(err, errCircuitOpen) {
+		t.Fatalf("allow while open = %v, want errCircuitOpen", err)
+	}
+
+	// After the cooldown exactly one trial call is admitted
+	later := now.Add(11 * time.Second)
+	if err := breaker.allow(later); err != nil {
+		t.Fatalf("trial call rejected: %v", err)
+	}
+	if err := breaker.allow(later); !errors.Is(err, errCircuitOpen) {
+		t.Fatalf("second call during trial = %v, want errCircuitOpen", err)
+	}
+
+	breaker.record(unavailable, later)
+	if got := breaker.currentState(); got != breakerOpen {
+		t.Fatalf("state after failed trial = %s, want %s", got, breakerOpen)
+	}
+
+	latest := later.Add(11 * time.Second)
+	if err := breaker.allow(latest); err != nil {
+		t.Fatalf("second trial call rejected: %v", err)
+	}
+	breaker.record(nil, latest)
+	if got := breaker.currentState(); got != breakerClosed {
+		t.Fatalf("state after successful trial = %s, want %s", got, breakerClosed)
+	}
+}
@@ -0,0 +1,655 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+const (
+	broadcastOptOutKey = "sih:broadcast:opt-out"
+
+	broadcastSourceIncident = "incident"
+	broadcastSourceAdvisory = "advisory"
+
+	maxBroadcastTitle   = 100
+	maxBroadcastMessage = 500
+
+	errCodeBroadcastsDisabled = "BROADCASTS_DISABLED"
+	errCodeBroadcastSource    = "BROADCAST_SOURCE_NOT_VERIFIED"
+)
+
+var (
+	broadcastSources       = []string{broadcastSourceIncident, broadcastSourceAdvisory}
+	errBroadcastUnverified = errors.New("the source may not be broadcast")
+)
+
+// BroadcastRequest warns the tourists near a verified incident or an active advisory. The area is
+// a geofence zone, or a circle that defaults to the incident's location and BROADCAST_RADIUS, or
+// the advisory's area.
+type BroadcastRequest struct {
+	SourceType   string   `json:"sourceType" binding:"required"`
+	SourceID     string   `json:"sourceID" binding:"required"`
+	ZoneID       string   `json:"zoneID"`
+	Latitude     *float64 `json:"latitude"`
+	Longitude    *float64 `json:"longitude"`
+	RadiusMeters float64  `json:"radiusMeters"`
+	Title        string   `json:"title"`
+	Message      string   `json:"message"`
+	Actor        string   `json:"actor" binding:"required"`
+}
+
+func (r BroadcastRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.oneOf("sourceType", r.SourceType, broadcastSources)
+	v.identifier("sourceID", r.SourceID)
+	v.identifier("actor", r.Actor)
+	if r.ZoneID != "" {
+		v.identifier("zoneID", r.ZoneID)
+		if r.Latitude != nil || r.Longitude != nil || r.RadiusMeters != 0 {
+			v.add("zoneID", "cannot be combined with a circle")
+		}
+	}
+	if (r.Latitude == nil) != (r.Longitude == nil) {
+		v.add("latitude", "must be given with longitude")
+	}
+	if r.Latitude != nil && (*r.Latitude < -90 || *r.Latitude > 90) {
+		v.add("latitude", "must be between -90 and 90")
+	}
+	if r.Longitude != nil && (*r.Longitude < -180 || *r.Longitude > 180) {
+		v.add("longitude", "must be between -180 and 180")
+	}
+	if r.RadiusMeters < 0 || r.RadiusMeters > maxZoneRadius {
+		v.add("radiusMeters", "must be between 0 and %d", maxZoneRadius)
+	}
+	if utf8.RuneCountInString(r.Title) > maxBroadcastTitle {
+		v.add("title", "must be at most %d characters", maxBroadcastTitle)
+	}
+	if utf8.RuneCountInString(r.Message) > maxBroadcastMessage {
+		v.add("message", "must be at most %d characters", maxBroadcastMessage)
+	}
+	return v.errors
+}
+
+// BroadcastListRequest selects the broadcasts of one incident or advisory
+type BroadcastListRequest struct {
+	SourceType string `form:"sourceType" binding:"required"`
+	SourceID   string `form:"sourceID" binding:"required"`
+}
+
+func (r BroadcastListRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.oneOf("sourceType", r.SourceType, broadcastSources)
+	v.identifier("sourceID", r.SourceID)
+	return v.errors
+}
+
+// Broadcast is a broadcast decision as the chaincode records it. The digests let the area, text
+// and recipients kept by whoever sent it be checked later without putting them on the ledger.
+type Broadcast struct {
+	BroadcastID      string `json:"broadcast_id"`
+	SourceType       string `json:"source_type"`
+	SourceID         string `json:"source_id"`
+	Severity         string `json:"severity"`
+	AreaDigest       string `json:"area_digest"`
+	ZoneID           string `json:"zone_id,omitempty"`
+	PingsSince       string `json:"pings_since"`
+	MessageDigest    string `json:"message_digest"`
+	RecipientsDigest string `json:"recipients_digest"`
+	Candidates       int    `json:"candidates"`
+	OptedOut         int    `json:"opted_out"`
+	Recipients       int    `json:"recipients"`
+	DecidedBy        string `json:"decided_by"`
+	DecidedAt        string `json:"decided_at"`
+	OwnerOrg         string `json:"owner_org,omitempty"`
+	TxID             string `json:"tx_id"`
+}
+
+// BroadcastResult is a recorded broadcast with the area and text behind its digests
+type BroadcastResult struct {
+	Broadcast Broadcast       `json:"broadcast"`
+	Area      GeoJSONGeometry `json:"area"`
+	Title     string          `json:"title"`
+	Message   string          `json:"message"`
+}
+
+// BroadcastOptOut says whether a tourist has opted out of proximity broadcasts
+type BroadcastOptOut struct {
+	DigitalID string `json:"digital_id"`
+	OptedOut  bool   `json:"opted_out"`
+}
+
+// broadcastOptOutStore keeps the tourists who opted out in a Redis set when the document cache is
+// configured, and in memory otherwise
+type broadcastOptOutStore interface {
+	set(ctx context.Context, digitalID string, optedOut bool) (bool, error)
+	// filter returns which of the tourists opted out
+	filter(ctx context.Context, digitalIDs []string) (map[string]bool, error)
+}
+
+// broadcastTarget is what a broadcast warns about and where
+type broadcastTarget struct {
+	sourceType string
+	sourceID   string
+	severity   string
+	area       *indexedZone
+	zoneID     string
+	title      string
+	message    string
+	data       map[string]string
+}
+
+// broadcaster pushes localized warnings to the tourists whose live position, recorded within
+// BROADCAST_RECENT_WITHIN, falls in an area. Each decision is recorded on the ledger before it is
+// delivered, so a broadcast the chaincode refuses reaches no one.
+type broadcaster struct {
+	optOuts      broadcastOptOutStore
+	radius       float64 // metres
+	recentWithin time.Duration
+	actor        string
+}
+
+var broadcasts *broadcaster
+
+// initBroadcasts runs after initPush and initLocation; without push delivery there is no one to
+// broadcast to
+func initBroadcasts() {
+	if pusher == nil || !getEnvBool("BROADCAST_ENABLED", true) {
+		log.Println("📢 Proximity broadcasts disabled")
+		return
+	}
+	b := &broadcaster{
+		radius:       float64(getEnvInt("BROADCAST_RADIUS", 2000)),
+		recentWithin: getEnvDuration("BROADCAST_RECENT_WITHIN", 2*time.Hour),
+		actor:        getEnv("BROADCAST_ACTOR", "proximity-broadcast"),
+	}
+	if b.radius <= 0 || b.radius > maxZoneRadius {
+		panic(fmt.Errorf("BROADCAST_RADIUS must be between 1 and %d metres", maxZoneRadius))
+	}
+	if b.recentWithin <= 0 {
+		panic(fmt.Errorf("BROADCAST_RECENT_WITHIN must be positive"))
+	}
+	var store broadcastOptOutStore = newMemoryBroadcastOptOutStore()
+	backend := "memory"
+	if documentCache != nil {
+		store, backend = redisBroadcastOptOutStore{documentCache}, "Redis"
+	}
+	b.optOuts = store
+	broadcasts = b
+	log.Printf("📢 Broadcasting acknowledged high-severity incidents within %.0fm to tourists seen in the last %s, opt-outs kept in %s", b.radius, b.recentWithin, backend)
+}
+
+func newBroadcastID(now time.Time) string {
+	suffix := make([]byte, 3)
+	rand.Read(suffix)
+	return fmt.Sprintf("BCAST-%s-%s", now.UTC().Format("20060102T150405Z"), hex.EncodeToString(suffix))
+}
+
+// broadcastRecipientsDigest hashes the broadcast ID and the sorted recipients, one per line. The
+// ID salts the digest, as with location anchors.
+func broadcastRecipientsDigest(broadcastID string, recipients []string) string {
+	sorted := slices.Clone(recipients)
+	sort.Strings(sorted)
+	sum := sha256.Sum256([]byte(broadcastID + "\n" + strings.Join(sorted, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// circleArea builds a circular search area
+func circleArea(id string, lat, lng, radius float64) (*indexedZone, error) {
+	raw, err := json.Marshal(GeoJSONGeometry{Type: "Circle", Coordinates: []float64{lng, lat}, Radius: radius})
+	if err != nil {
+		return nil, err
+	}
+	return newIndexedZone(ZoneDocument{ZoneID: id, Geometry: string(raw)})
+}
+
+// incidentArea centres the default area on the incident's geohash cell, widened to cover the
+// whole cell, as the ledger keeps only a coarse location
+func (b *broadcaster) incidentArea(incident IncidentDocument) (*indexedZone, error) {
+	cell, ok := geohashBounds(incident.Geohash)
+	if !ok {
+		return nil, ValidationErrors{{Field: "latitude", Message: "is required, as the incident has no location"}}
+	}
+	lat, lng := (cell.minLat+cell.maxLat)/2, (cell.minLng+cell.maxLng)/2
+	radius := math.Min(math.Max(b.radius, haversineKm(lat, lng, cell.maxLat, cell.maxLng)*1000), maxZoneRadius)
+	return circleArea(incident.IncidentID, lat, lng, radius)
+}
+
+func incidentTarget(incident IncidentDocument) (broadcastTarget, error) {
+	if incident.Status != "acknowledged" {
+		return broadcastTarget{}, fmt.Errorf("%w: the incident %s is %s, not acknowledged", errBroadcastUnverified, incident.IncidentID, incident.Status)
+	}
+	if severityLevel(incident.Severity) < severityLevel("high") {
+		return broadcastTarget{}, fmt.Errorf("%w: the incident %s is of %s severity", errBroadcastUnverified, incident.IncidentID, incident.Severity)
+	}
+	return broadcastTarget{
+		sourceType: broadcastSourceIncident,
+		sourceID:   incident.IncidentID,
+		severity:   incident.Severity,
+		title:      strings.ToUpper(incident.Severity[:1]) + incident.Severity[1:] + " severity incident nearby",
+		message:    "Police are responding to an incident near you. Avoid the area and follow official instructions.",
+		data:       map[string]string{"type": "broadcast", "incident_id": incident.IncidentID, "severity": incident.Severity},
+	}, nil
+}
+
+func advisoryTarget(alert WeatherAlert, now time.Time) (broadcastTarget, error) {
+	if alert.expired(now) {
+		return broadcastTarget{}, fmt.Errorf("%w: the weather alert %s has expired", errBroadcastUnverified, alert.AlertID)
+	}
+	if advisories != nil && weatherSeverity(alert.Severity) < advisories.notifyMin {
+		return broadcastTarget{}, fmt.Errorf("%w: the weather alert %s is below WEATHER_NOTIFY_MIN_SEVERITY", errBroadcastUnverified, alert.AlertID)
+	}
+	raw, err := json.Marshal(alert.Geometry)
+	if err != nil {
+		return broadcastTarget{}, err
+	}
+	area, err := newIndexedZone(ZoneDocument{ZoneID: alert.AlertID, Geometry: string(raw)})
+	if err != nil {
+		return broadcastTarget{}, err
+	}
+	return broadcastTarget{
+		sourceType: broadcastSourceAdvisory,
+		sourceID:   alert.AlertID,
+		severity:   alert.Severity,
+		area:       area,
+		title:      "Weather " + alert.Severity + " for your area",
+		message:    alert.Headline,
+		data:       map[string]string{"type": "weather", "alert_id": alert.AlertID, "severity": alert.Severity, "expires_at": alert.ExpiresAt},
+	}, nil
+}
+
+// recipients returns every tourist recently seen inside the area, and those of them to warn
+func (b *broadcaster) recipients(ctx context.Context, area *indexedZone, since time.Time) ([]string, []string, error) {
+	found, err := locations.store.within(ctx, area.bbox, since)
+	if err != nil {
+		return nil, nil, err
+	}
+	var candidates []string
+	for _, live := range found {
+		if area.contains(live.Longitude, live.Latitude) {
+			candidates = append(candidates, live.DigitalID)
+		}
+	}
+	sort.Strings(candidates)
+	candidates = slices.Compact(candidates)
+	optedOut, err := b.optOuts.filter(ctx, candidates)
+	if err != nil {
+		return nil, nil, err
+	}
+	recipients := make([]string, 0, len(candidates))
+	for _, digitalID := range candidates {
+		if !optedOut[digitalID] {
+			recipients = append(recipients, digitalID)
+		}
+	}
+	return candidates, recipients, nil
+}
+
+// send records the decision to warn the tourists in the target's area, then pushes the warning
+// to their apps
+func (b *broadcaster) send(ctx context.Context, target broadcastTarget, actor string) (*BroadcastResult, error) {
+	now := time.Now()
+	since := now.Add(-b.recentWithin).UTC().Truncate(time.Second)
+	candidates, recipients, err := b.recipients(ctx, target.area, since)
+	if err != nil {
+		return nil, err
+	}
+	area, err := json.Marshal(target.area.zone.Geometry)
+	if err != nil {
+		return nil, err
+	}
+
+	id := newBroadcastID(now)
+	_, err = submitTransaction(ctx, "RecordBroadcast", id, target.sourceType, target.sourceID, target.severity, sha256Hex(area), target.zoneID,
+		since.Format(time.RFC3339), sha256Hex([]byte(target.title+"\n"+target.message)), broadcastRecipientsDigest(id, recipients),
+		strconv.Itoa(len(candidates)), strconv.Itoa(len(candidates)-len(recipients)), strconv.Itoa(len(recipients)), actor)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := evaluateTransaction(ctx, "ReadBroadcast", id)
+	if err != nil {
+		return nil, err
+	}
+	broadcast, err := decodeDocument[Broadcast](payload, "broadcast")
+	if err != nil {
+		return nil, err
+	}
+
+	if len(recipients) > 0 {
+		tourists := make(map[string]bool, len(recipients))
+		for _, digitalID := range recipients {
+			tourists[digitalID] = true
+		}
+		data := map[string]string{"broadcast_id": id}
+		for key, value := range target.data {
+			data[key] = value
+		}
+		go pusher.deliver(context.WithoutCancel(ctx), pushAlert{key: "broadcast:" + id, tourists: tourists, title: target.title, body: target.message, data: data})
+	}
+	logWithContext(ctx, "📢 Broadcast %s for %s %s reached %d of %d tourists nearby", id, target.sourceType, target.sourceID, len(recipients), len(candidates))
+	return &BroadcastResult{Broadcast: broadcast, Area: target.area.zone.Geometry, Title: target.title, Message: target.message}, nil
+}
+
+// advisory broadcasts an ingested advisory over its own area
+func (b *broadcaster) advisory(ctx context.Context, alert WeatherAlert, actor string) (*BroadcastResult, error) {
+	target, err := advisoryTarget(alert, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return b.send(withTarget(ctx, defaultTarget), target, actor)
+}
+
+// broadcastsFromEvent warns the tourists near an incident once it is acknowledged, if it is of
+// high or critical severity and has a location. With several gateway instances the first to claim
+// the event broadcasts.
+func broadcastsFromEvent(ctx context.Context, event *client.ChaincodeEvent) {
+	if broadcasts == nil || !isDefaultTarget(ctx) || event.EventName != "UpdateIncidentStatus" {
+		return
+	}
+	var incident IncidentDocument
+	if err := json.Unmarshal(event.Payload, &incident); err != nil || incident.Geohash == "" {
+		return
+	}
+	target, err := incidentTarget(incident)
+	if err != nil {
+		return
+	}
+	go func() {
+		ctx := context.WithoutCancel(ctx)
+		if first, err := claimEvent(ctx, "broadcast:"+event.TransactionID, 24*time.Hour); err != nil || !first {
+			return
+		}
+		if target.area, err = broadcasts.incidentArea(incident); err != nil {
+			return
+		}
+		if _, err := broadcasts.send(ctx, target, broadcasts.actor); err != nil {
+			log.Printf("📢 Failed to broadcast incident %s: %v", incident.IncidentID, err)
+		}
+	}()
+}
+
+// Broadcast warns the tourists near an incident the caller's organization owns or an active
+// weather alert, over the requested area
+func (ledgerService) Broadcast(ctx context.Context, req BroadcastRequest) (*BroadcastResult, error) {
+	if errs := req.Validate(); len(errs) > 0 {
+		return nil, errs
+	}
+
+	var target broadcastTarget
+	switch req.SourceType {
+	case broadcastSourceIncident:
+		incident, err := ledger.ownedIncident(ctx, req.SourceID)
+		if err != nil {
+			return nil, err
+		}
+		if target, err = incidentTarget(incident); err != nil {
+			return nil, err
+		}
+		if req.ZoneID == "" && req.Latitude == nil {
+			if target.area, err = broadcasts.incidentArea(incident); err != nil {
+				return nil, err
+			}
+		}
+	case broadcastSourceAdvisory:
+		alert, err := findWeatherAlert(ctx, req.SourceID)
+		if err != nil {
+			return nil, err
+		}
+		if alert == nil {
+			return nil, fmt.Errorf("the weather alert %s does not exist", req.SourceID)
+		}
+		if target, err = advisoryTarget(*alert, time.Now()); err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case req.ZoneID != "":
+		zone, ok := geofence.zone(req.ZoneID)
+		if !ok {
+			return nil, fmt.Errorf("the zone %s does not exist", req.ZoneID)
+		}
+		target.area, target.zoneID = zone, req.ZoneID
+	case req.Latitude != nil:
+		radius := req.RadiusMeters
+		if radius == 0 {
+			radius = broadcasts.radius
+		}
+		area, err := circleArea(req.SourceID, *req.Latitude, *req.Longitude, radius)
+		if err != nil {
+			return nil, err
+		}
+		target.area = area
+	}
+	if req.Title != "" {
+		target.title = req.Title
+	}
+	if req.Message != "" {
+		target.message = req.Message
+	}
+	return broadcasts.send(ctx, target, req.Actor)
+}
+
+// Broadcasts lists the broadcasts of an incident the caller may read, or of an advisory
+func (ledgerService) Broadcasts(ctx context.Context, req BroadcastListRequest) ([]Broadcast, error) {
+	if errs := req.Validate(); len(errs) > 0 {
+		return nil, errs
+	}
+	if req.SourceType == broadcastSourceIncident {
+		if _, err := ledger.GetIncident(ctx, req.SourceID); err != nil {
+			return nil, err
+		}
+	}
+	result, err := evaluateTransaction(ctx, "GetBroadcasts", req.SourceType, req.SourceID)
+	if err != nil {
+		return nil, err
+	}
+	list, err := decodeDocument[[]Broadcast](result, "broadcast")
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].DecidedAt > list[j].DecidedAt })
+	return list, nil
+}
+
+// redisBroadcastOptOutStore keeps the opted-out tourists in one set
+type redisBroadcastOptOutStore struct {
+	redis *redisClient
+}
+
+func (s redisBroadcastOptOutStore) set(ctx context.Context, digitalID string, optedOut bool) (bool, error) {
+	command := "SREM"
+	if optedOut {
+		command = "SADD"
+	}
+	reply, err := s.redis.Do(ctx, command, broadcastOptOutKey, digitalID)
+	changed, _ := reply.(int64)
+	return changed > 0, err
+}
+
+func (s redisBroadcastOptOutStore) filter(ctx context.Context, digitalIDs []string) (map[string]bool, error) {
+	optedOut := map[string]bool{}
+	if len(digitalIDs) == 0 {
+		return optedOut, nil
+	}
+	reply, err := s.redis.Do(ctx, append([]string{"SMISMEMBER", broadcastOptOutKey}, digitalIDs...)...)
+	if err != nil {
+		return nil, err
+	}
+	flags, _ := reply.([]interface{})
+	for i, flag := range flags {
+		if member, _ := flag.(int64); member == 1 && i < len(digitalIDs) {
+			optedOut[digitalIDs[i]] = true
+		}
+	}
+	return optedOut, nil
+}
+
+// memoryBroadcastOptOutStore serves a single gateway instance
+type memoryBroadcastOptOutStore struct {
+	mu       sync.Mutex
+	optedOut map[string]bool
+}
+
+func newMemoryBroadcastOptOutStore() *memoryBroadcastOptOutStore {
+	return &memoryBroadcastOptOutStore{optedOut: map[string]bool{}}
+}
+
+func (s *memoryBroadcastOptOutStore) set(_ context.Context, digitalID string, optedOut bool) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	changed := s.optedOut[digitalID] != optedOut
+	if optedOut {
+		s.optedOut[digitalID] = true
+	} else {
+		delete(s.optedOut, digitalID)
+	}
+	return changed, nil
+}
+
+func (s *memoryBroadcastOptOutStore) filter(_ context.Context, digitalIDs []string) (map[string]bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	optedOut := map[string]bool{}
+	for _, digitalID := range digitalIDs {
+		if s.optedOut[digitalID] {
+			optedOut[digitalID] = true
+		}
+	}
+	return optedOut, nil
+}
+
+func broadcastsDisabled(c *gin.Context) bool {
+	if broadcasts == nil {
+		respondError(c, http.StatusServiceUnavailable, errCodeBroadcastsDisabled, "Proximity broadcasts need push notifications; set FCM_PROJECT_ID")
+		return true
+	}
+	return false
+}
+
+func createBroadcast(c *gin.Context) {
+	if broadcastsDisabled(c) {
+		return
+	}
+	var req BroadcastRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+	setAuditTarget(c, req.SourceID)
+
+	result, err := ledger.Broadcast(c.Request.Context(), req)
+	if errors.Is(err, errBroadcastUnverified) {
+		respondError(c, http.StatusConflict, errCodeBroadcastSource, strings.TrimPrefix(err.Error(), errBroadcastUnverified.Error()+": "))
+		return
+	}
+	if err != nil {
+		respondServiceError(c, "Failed to broadcast", err)
+		return
+	}
+	respondData(c, http.StatusCreated, result)
+}
+
+func listBroadcasts(c *gin.Context) {
+	var req BroadcastListRequest
+	if !bindQuery(c, &req) {
+		return
+	}
+	list, err := ledger.Broadcasts(c.Request.Context(), req)
+	if err != nil {
+		respondServiceError(c, "Failed to list broadcasts", err)
+		return
+	}
+	respondData(c, http.StatusOK, list)
+}
+
+func getBroadcast(c *gin.Context) {
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	result, err := evaluateTransaction(c.Request.Context(), "ReadBroadcast", id)
+	if err != nil {
+		respondServiceError(c, "Failed to read broadcast", err)
+		return
+	}
+	broadcast, err := decodeDocument[Broadcast](result, "broadcast")
+	if err == nil && broadcast.SourceType == broadcastSourceIncident {
+		_, err = ledger.GetIncident(c.Request.Context(), broadcast.SourceID)
+	}
+	if err != nil {
+		respondServiceError(c, "Failed to read broadcast", err)
+		return
+	}
+	respondData(c, http.StatusOK, broadcast)
+}
+
+// broadcastTourist reads the tourist a broadcast opt-out route names
+func broadcastTourist(c *gin.Context) (string, bool) {
+	if broadcastsDisabled(c) {
+		return "", false
+	}
+	digitalID := c.Param("digitalId")
+	var v fieldValidator
+	if v.digitalID("digitalId", digitalID); len(v.errors) > 0 {
+		respondValidationErrors(c, v.errors)
+		return "", false
+	}
+	return digitalID, true
+}
+
+// broadcastOptOutHandler opts a tourist out of proximity broadcasts, or back in
+func broadcastOptOutHandler(optedOut bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		digitalID, ok := broadcastTourist(c)
+		if !ok {
+			return
+		}
+		setAuditTarget(c, digitalID)
+		if _, err := broadcasts.optOuts.set(c.Request.Context(), digitalID, optedOut); err != nil {
+			respondServiceError(c, "Failed to update broadcast opt-out", err)
+			return
+		}
+		respondData(c, http.StatusOK, BroadcastOptOut{DigitalID: digitalID, OptedOut: optedOut})
+	}
+}
+
+func getBroadcastOptOut(c *gin.Context) {
+	digitalID, ok := broadcastTourist(c)
+	if !ok {
+		return
+	}
+	optedOut, err := broadcasts.optOuts.filter(c.Request.Context(), []string{digitalID})
+	if err != nil {
+		respondServiceError(c, "Failed to read broadcast opt-out", err)
+		return
+	}
+	respondData(c, http.StatusOK, BroadcastOptOut{DigitalID: digitalID, OptedOut: optedOut[digitalID]})
+}
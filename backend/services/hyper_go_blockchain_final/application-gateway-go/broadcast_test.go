@@ -0,0 +1,111 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestBroadcastRecipients(t *testing.T) {
+	saved := locations
+	defer func() { locations = saved }()
+	locations = &locationService{store: newMemoryLocationStore(time.Hour)}
+	b := &broadcaster{optOuts: newMemoryBroadcastOptOutStore(), radius: 1000, recentWithin: time.Hour}
+	ctx := context.Background()
+	now := time.Now().UTC()
+
+	seen := func(digitalID string, lat, lng float64, ago time.Duration) {
+		locations.store.update(ctx, LiveLocation{DigitalID: digitalID, Latitude: lat, Longitude: lng,
+			RecordedAt: now.Add(-ago).Format(time.RFC3339), ReceivedAt: now.Format(time.RFC3339)})
+	}
+	seen("did:sih:near", 25.5790, 91.8935, time.Minute)
+	seen("did:sih:optedout", 25.5785, 91.8930, time.Minute)
+	seen("did:sih:corner", 25.5870, 91.9020, time.Minute) // inside the bounding box, outside the circle
+	seen("did:sih:far", 25.6500, 91.9500, time.Minute)
+	seen("did:sih:stale", 25.5788, 91.8933, 3*time.Hour)
+	b.optOuts.set(ctx, "did:sih:optedout", true)
+
+	area, err := circleArea("INC-1", 25.5788, 91.8933, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	candidates, recipients, err := b.recipients(ctx, area, now.Add(-b.recentWithin))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(candidates, []string{"did:sih:near", "did:sih:optedout"}) {
+		t.Errorf("expected the two tourists recently seen in the circle, got %v", candidates)
+	}
+	if !slices.Equal(recipients, []string{"did:sih:near"}) {
+		t.Errorf("expected the opted-out tourist skipped, got %v", recipients)
+	}
+
+	b.optOuts.set(ctx, "did:sih:optedout", false)
+	if _, recipients, _ := b.recipients(ctx, area, now.Add(-b.recentWithin)); len(recipients) != 2 {
+		t.Errorf("expected the tourist warned after opting back in, got %v", recipients)
+	}
+	if a, b := broadcastRecipientsDigest("BCAST-1", []string{"b", "a"}), broadcastRecipientsDigest("BCAST-1", []string{"a", "b"}); a != b {
+		t.Error("expected the recipients digest independent of order")
+	}
+	if a, b := broadcastRecipientsDigest("BCAST-1", []string{"a"}), broadcastRecipientsDigest("BCAST-2", []string{"a"}); a == b {
+		t.Error("expected the recipients digest salted with the broadcast ID")
+	}
+}
+
+func TestBroadcastSources(t *testing.T) {
+	incident := IncidentDocument{IncidentID: "INC-1", Status: "acknowledged", Severity: "critical", Geohash: "wh6u2"}
+	if _, err := incidentTarget(incident); err != nil {
+		t.Errorf("expected an acknowledged critical incident broadcast, got %v", err)
+	}
+	for _, unverified := range []IncidentDocument{
+		{IncidentID: "INC-2", Status: "open", Severity: "critical"},
+		{IncidentID: "INC-3", Status: "acknowledged", Severity: "medium"},
+	} {
+		if _, err := incidentTarget(unverified); !errors.Is(err, errBroadcastUnverified) {
+			t.Errorf("expected %s refused, got %v", unverified.IncidentID, err)
+		}
+	}
+
+	b := &broadcaster{radius: 500}
+	area, err := b.incidentArea(incident)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cell, _ := geohashBounds(incident.Geohash)
+	if area.circle == nil || area.circle.radius <= b.radius || !area.contains(cell.maxLng, cell.maxLat) {
+		t.Errorf("expected the circle widened to cover the geohash cell, got %+v", area.circle)
+	}
+	if _, err := b.incidentArea(IncidentDocument{IncidentID: "INC-4"}); err == nil {
+		t.Error("expected an incident without a location to need an area")
+	}
+
+	now := time.Now()
+	alert := WeatherAlert{AlertID: "CAP-1", Severity: "warning", Headline: "Heavy rainfall", ExpiresAt: now.Add(time.Hour).UTC().Format(time.RFC3339),
+		Geometry: GeoJSONGeometry{Type: "Polygon", Coordinates: [][][]float64{{{91.8, 25.5}, {91.9, 25.5}, {91.9, 25.6}, {91.8, 25.5}}}}}
+	if target, err := advisoryTarget(alert, now); err != nil || target.message != "Heavy rainfall" || !target.area.contains(91.88, 25.52) {
+		t.Errorf("expected the advisory broadcast over its own area, got %+v, %v", target, err)
+	}
+	if _, err := advisoryTarget(alert, now.Add(2*time.Hour)); !errors.Is(err, errBroadcastUnverified) {
+		t.Errorf("expected an expired advisory refused, got %v", err)
+	}
+}
+
+func TestBroadcastRequestValidate(t *testing.T) {
+	lat, lng := 25.5788, 91.8933
+	valid := BroadcastRequest{SourceType: "incident", SourceID: "INC-1", Latitude: &lat, Longitude: &lng, RadiusMeters: 1500, Actor: "control_room"}
+	if errs := valid.Validate(); len(errs) > 0 {
+		t.Errorf("expected a valid request, got %v", errs)
+	}
+	for name, req := range map[string]BroadcastRequest{
+		"zone and circle": {SourceType: "incident", SourceID: "INC-1", ZoneID: "ZONE-1", Latitude: &lat, Longitude: &lng, Actor: "control_room"},
+		"latitude alone":  {SourceType: "incident", SourceID: "INC-1", Latitude: &lat, Actor: "control_room"},
+		"wide radius":     {SourceType: "advisory", SourceID: "CAP-1", Latitude: &lat, Longitude: &lng, RadiusMeters: maxZoneRadius + 1, Actor: "control_room"},
+		"unknown source":  {SourceType: "rumour", SourceID: "X-1", Actor: "control_room"},
+	} {
+		if errs := req.Validate(); len(errs) == 0 {
+			t.Errorf("expected %s rejected", name)
+		}
+	}
+}
@@ -0,0 +1,354 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	maxDIDsPerTx      = 200
+	bulkManifestField = "manifest"
+)
+
+// Bulk issuance row outcomes
+const (
+	bulkIssued   = "issued"
+	bulkExisting = "exists"
+	bulkInvalid  = "invalid"
+	bulkFailed   = "failed"
+)
+
+// BulkDIDRequest chooses the report format: JSON by default, or a csv or xlsx download
+type BulkDIDRequest struct {
+	Format string `form:"format"`
+}
+
+func (r BulkDIDRequest) Validate() ValidationErrors {
+	return exportFormatErrors(r.Format)
+}
+
+// BulkDIDRow is the outcome of one manifest row. Row counts from 1 and excludes a CSV header.
+type BulkDIDRow struct {
+	Row       int      `json:"row"`
+	DigitalID string   `json:"digital_id"`
+	Status    string   `json:"status"`
+	TxID      string   `json:"tx_id,omitempty"`
+	Errors    []string `json:"errors,omitempty"`
+}
+
+// BulkDIDReport summarizes a bulk issuance
+type BulkDIDReport struct {
+	Total    int          `json:"total"`
+	Issued   int          `json:"issued"`
+	Existing int          `json:"existing"`
+	Invalid  int          `json:"invalid"`
+	Failed   int          `json:"failed"`
+	Rows     []BulkDIDRow `json:"rows"`
+}
+
+// didIssuance is one DID in a BatchIssueDID transaction
+type didIssuance struct {
+	DigitalID   string `json:"digital_id"`
+	ConsentHash string `json:"consent_hash"`
+	ExpiresAt   string `json:"expires_at"`
+	Issuer      string `json:"issuer"`
+}
+
+type batchIssueResult struct {
+	Issued   []string `json:"issued"`
+	Existing []string `json:"existing"`
+}
+
+type bulkIssuanceConfig struct {
+	maxRows   int
+	maxBytes  int64
+	batchSize int
+}
+
+var bulkIssuance = bulkIssuanceConfig{maxRows: 1000, maxBytes: 5 << 20, batchSize: 100}
+
+// initBulkIssuance sizes the manifests accepted by POST /did/bulk and the batches they are
+// submitted in
+func initBulkIssuance() {
+	bulkIssuance = bulkIssuanceConfig{
+		maxRows:   getEnvInt("BULK_DID_MAX_ROWS", 1000),
+		maxBytes:  int64(getEnvInt("BULK_DID_MAX_BYTES", 5<<20)),
+		batchSize: getEnvInt("BULK_DID_BATCH_SIZE", 100),
+	}
+	if bulkIssuance.batchSize < 1 || bulkIssuance.batchSize > maxDIDsPerTx {
+		panic(fmt.Errorf("BULK_DID_BATCH_SIZE must be between 1 and %d", maxDIDsPerTx))
+	}
+}
+
+// manifestColumns maps CSV header names to CreateDIDRequest fields; camelCase and snake_case are
+// both accepted
+var manifestColumns = map[string]string{
+	"digitalid": "digitalID", "digital_id": "digitalID",
+	"consenthash": "consentHash", "consent_hash": "consentHash",
+	"expiresat": "expiresAt", "expires_at": "expiresAt",
+	"issuer": "issuer",
+}
+
+// parseDIDManifest reads the group's DIDs from a JSON array, a {"members": [...]} object, a CSV with
+// a header row, or a multipart form with either in its manifest file
+func parseDIDManifest(contentType string, body io.Reader) ([]CreateDIDRequest, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = "application/json"
+	}
+	switch {
+	case mediaType == "multipart/form-data":
+		return parseMultipartManifest(body, params["boundary"])
+	case mediaType == "text/csv" || mediaType == "application/csv":
+		return parseCSVManifest(body)
+	case mediaType == "application/json":
+		return parseJSONManifest(body)
+	}
+	return nil, fmt.Errorf("must be application/json, text/csv or multipart/form-data, not %s", mediaType)
+}
+
+func parseMultipartManifest(body io.Reader, boundary string) ([]CreateDIDRequest, error) {
+	if boundary == "" {
+		return nil, errors.New("multipart body has no boundary")
+	}
+	reader := multipart.NewReader(body, boundary)
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("the %s file is required", bulkManifestField)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() != bulkManifestField {
+			part.Close()
+			continue
+		}
+		if strings.EqualFold(path.Ext(part.FileName()), ".json") || strings.HasPrefix(part.Header.Get("Content-Type"), "application/json") {
+			return parseJSONManifest(part)
+		}
+		return parseCSVManifest(part)
+	}
+}
+
+func parseJSONManifest(body io.Reader) ([]CreateDIDRequest, error) {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	var members []CreateDIDRequest
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		var wrapped struct {
+			Members []CreateDIDRequest `json:"members"`
+		}
+		err = json.Unmarshal(trimmed, &wrapped)
+		members = wrapped.Members
+	} else {
+		err = json.Unmarshal(trimmed, &members)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSON manifest: %v", err)
+	}
+	return members, nil
+}
+
+func parseCSVManifest(body io.Reader) ([]CreateDIDRequest, error) {
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, errors.New("the CSV manifest is empty")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if field, ok := manifestColumns[name]; ok {
+			columns[field] = i
+		}
+	}
+	for _, field := range []string{"digitalID", "consentHash", "expiresAt", "issuer"} {
+		if _, ok := columns[field]; !ok {
+			return nil, fmt.Errorf("the CSV header must have a %s column", field)
+		}
+	}
+
+	var members []CreateDIDRequest
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return members, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		value := func(field string) string {
+			if i := columns[field]; i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		members = append(members, CreateDIDRequest{
+			DigitalID:   value("digitalID"),
+			ConsentHash: value("consentHash"),
+			ExpiresAt:   value("expiresAt"),
+			Issuer:      value("issuer"),
+		})
+	}
+}
+
+// issueDIDs validates every row, then submits the valid ones in batches. A batch that fails marks
+// only its own rows as failed.
+func issueDIDs(ctx context.Context, members []CreateDIDRequest, batchSize int, submit func(ctx context.Context, payload string) (*TransactionResult, error)) BulkDIDReport {
+	report := BulkDIDReport{Total: len(members), Rows: make([]BulkDIDRow, len(members))}
+	firstRow := map[string]int{}
+	var pending []int
+	for i, member := range members {
+		row := &report.Rows[i]
+		row.Row, row.DigitalID = i+1, member.DigitalID
+		for _, fe := range member.Validate() {
+			row.Errors = append(row.Errors, fe.Field+": "+fe.Message)
+		}
+		if first, ok := firstRow[member.DigitalID]; ok && member.DigitalID != "" {
+			row.Errors = append(row.Errors, fmt.Sprintf("digitalID: repeats row %d", first))
+		} else {
+			firstRow[member.DigitalID] = row.Row
+		}
+		if len(row.Errors) > 0 {
+			row.Status = bulkInvalid
+			continue
+		}
+		pending = append(pending, i)
+	}
+
+	for start := 0; start < len(pending); start += batchSize {
+		batch := pending[start:min(start+batchSize, len(pending))]
+		issuances := make([]didIssuance, 0, len(batch))
+		for _, i := range batch {
+			m := members[i]
+			issuances = append(issuances, didIssuance{DigitalID: m.DigitalID, ConsentHash: m.ConsentHash, ExpiresAt: m.ExpiresAt, Issuer: m.Issuer})
+		}
+		payload, _ := json.Marshal(issuances)
+
+		var result batchIssueResult
+		tx, err := submit(ctx, string(payload))
+		if err == nil {
+			err = json.Unmarshal(tx.Payload, &result)
+		}
+		if err != nil {
+			message := translateFabricError(err).Message
+			logWithContext(ctx, "Bulk DID batch of %d failed: %v", len(batch), err)
+			for _, i := range batch {
+				report.Rows[i].Status, report.Rows[i].Errors = bulkFailed, []string{message}
+			}
+			continue
+		}
+		existing := map[string]bool{}
+		for _, id := range result.Existing {
+			existing[id] = true
+		}
+		for _, i := range batch {
+			row := &report.Rows[i]
+			row.Status, row.TxID = bulkIssued, tx.TxID
+			if existing[row.DigitalID] {
+				row.Status, row.TxID = bulkExisting, ""
+			}
+		}
+	}
+
+	for _, row := range report.Rows {
+		switch row.Status {
+		case bulkIssued:
+			report.Issued++
+		case bulkExisting:
+			report.Existing++
+		case bulkInvalid:
+			report.Invalid++
+		case bulkFailed:
+			report.Failed++
+		}
+	}
+	return report
+}
+
+// IssueDIDs issues a group's DIDs with BatchIssueDID
+func (ledgerService) IssueDIDs(ctx context.Context, members []CreateDIDRequest) (BulkDIDReport, error) {
+	if len(members) == 0 {
+		return BulkDIDReport{}, ValidationErrors{{Field: bulkManifestField, Message: "must list at least one member"}}
+	}
+	if len(members) > bulkIssuance.maxRows {
+		return BulkDIDReport{}, ValidationErrors{{Field: bulkManifestField, Message: fmt.Sprintf("must list at most %d members", bulkIssuance.maxRows)}}
+	}
+	return issueDIDs(ctx, members, bulkIssuance.batchSize, func(ctx context.Context, payload string) (*TransactionResult, error) {
+		return submitTransaction(ctx, "BatchIssueDID", payload)
+	}), nil
+}
+
+// bulkCreateDIDs issues DIDs for every member of a tour group from one manifest and reports the
+// outcome of each row, as JSON or as a CSV or XLSX download
+func bulkCreateDIDs(c *gin.Context) {
+	var req BulkDIDRequest
+	if !bindQuery(c, &req) {
+		return
+	}
+	if c.Request.ContentLength > bulkIssuance.maxBytes {
+		respondError(c, http.StatusRequestEntityTooLarge, errCodePayloadTooLarge, fmt.Sprintf("The manifest must not exceed %d bytes", bulkIssuance.maxBytes))
+		return
+	}
+	body := http.MaxBytesReader(c.Writer, c.Request.Body, bulkIssuance.maxBytes)
+
+	members, err := parseDIDManifest(c.GetHeader("Content-Type"), body)
+	if err != nil {
+		var maxBytes *http.MaxBytesError
+		if errors.As(err, &maxBytes) {
+			respondError(c, http.StatusRequestEntityTooLarge, errCodePayloadTooLarge, fmt.Sprintf("The manifest must not exceed %d bytes", bulkIssuance.maxBytes))
+			return
+		}
+		respondValidationErrors(c, ValidationErrors{{Field: bulkManifestField, Message: err.Error()}})
+		return
+	}
+
+	report, err := ledger.IssueDIDs(c.Request.Context(), members)
+	if err != nil {
+		respondServiceError(c, "Failed to issue DIDs", err)
+		return
+	}
+	log.Printf("Bulk DID issuance: %d issued, %d existing, %d invalid, %d failed of %d", report.Issued, report.Existing, report.Invalid, report.Failed, report.Total)
+
+	if req.Format == "" {
+		respondData(c, http.StatusOK, report)
+		return
+	}
+	header := []string{"row", "digital_id", "status", "tx_id", "errors"}
+	streamExport(c, "did-issuance", req.Format, header, func(string) ([][]string, string, error) {
+		rows := make([][]string, 0, len(report.Rows))
+		for _, row := range report.Rows {
+			rows = append(rows, []string{strconv.Itoa(row.Row), row.DigitalID, row.Status, row.TxID, strings.Join(row.Errors, "; ")})
+		}
+		return rows, "", nil
+	})
+}
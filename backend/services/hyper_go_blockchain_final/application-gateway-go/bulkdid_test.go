@@ -0,0 +1,86 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"mime/multipart"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseDIDManifest(t *testing.T) {
+	csvManifest := "\ufeffdigital_id, consent_hash,expires_at,issuer,notes\n" +
+		"did:sih:g1,abc,2030-01-01T00:00:00Z,operator_1,front row\n" +
+		"did:sih:g2,def,2030-01-01T00:00:00Z,operator_1\n"
+	members, err := parseDIDManifest("text/csv; charset=utf-8", strings.NewReader(csvManifest))
+	if err != nil || len(members) != 2 || members[0].ConsentHash != "abc" || members[1].DigitalID != "did:sih:g2" || members[1].Issuer != "operator_1" {
+		t.Fatalf("unexpected CSV manifest %+v: %v", members, err)
+	}
+	if _, err := parseDIDManifest("text/csv", strings.NewReader("digitalID,issuer\n")); err == nil {
+		t.Error("expected a CSV without a consentHash column rejected")
+	}
+
+	members, err = parseDIDManifest("application/json", strings.NewReader(`{"members":[{"digitalID":"did:sih:g3"}]}`))
+	if err != nil || len(members) != 1 || members[0].DigitalID != "did:sih:g3" {
+		t.Errorf("unexpected wrapped JSON manifest %+v: %v", members, err)
+	}
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("note", "ignored")
+	file, _ := form.CreateFormFile(bulkManifestField, "group.json")
+	file.Write([]byte(`[{"digitalID":"did:sih:g4"},{"digitalID":"did:sih:g5"}]`))
+	form.Close()
+	members, err = parseDIDManifest(form.FormDataContentType(), &body)
+	if err != nil || len(members) != 2 || members[1].DigitalID != "did:sih:g5" {
+		t.Errorf("unexpected multipart manifest %+v: %v", members, err)
+	}
+}
+
+func TestIssueDIDs(t *testing.T) {
+	hash := strings.Repeat("a", 64)
+	expires := time.Now().Add(30 * 24 * time.Hour).UTC().Format(time.RFC3339)
+	member := func(id string) CreateDIDRequest {
+		return CreateDIDRequest{DigitalID: id, ConsentHash: hash, ExpiresAt: expires, Issuer: "operator_1"}
+	}
+	members := []CreateDIDRequest{
+		member("did:sih:g1"),
+		{DigitalID: "did:sih:g2", ConsentHash: "nope", ExpiresAt: expires, Issuer: "operator_1"},
+		member("did:sih:g3"),
+		member("did:sih:g1"),
+		member("did:sih:g4"),
+	}
+
+	var batches [][]didIssuance
+	report := issueDIDs(context.Background(), members, 2, func(ctx context.Context, payload string) (*TransactionResult, error) {
+		var batch []didIssuance
+		json.Unmarshal([]byte(payload), &batch)
+		batches = append(batches, batch)
+		if len(batches) == 2 {
+			return nil, errors.New("endorsement timed out")
+		}
+		result, _ := json.Marshal(batchIssueResult{Issued: []string{"did:sih:g1"}, Existing: []string{"did:sih:g3"}})
+		return &TransactionResult{Payload: result, TxID: "tx-1"}, nil
+	})
+
+	if len(batches) != 2 || len(batches[0]) != 2 || len(batches[1]) != 1 || batches[1][0].DigitalID != "did:sih:g4" {
+		t.Fatalf("expected the three valid rows submitted in batches of two, got %+v", batches)
+	}
+	expected := []struct{ status, txID string }{
+		{bulkIssued, "tx-1"}, {bulkInvalid, ""}, {bulkExisting, ""}, {bulkInvalid, ""}, {bulkFailed, ""},
+	}
+	for i, want := range expected {
+		if row := report.Rows[i]; row.Row != i+1 || row.Status != want.status || row.TxID != want.txID {
+			t.Errorf("row %d = %+v, expected %s %q", i+1, row, want.status, want.txID)
+		}
+	}
+	if !strings.Contains(strings.Join(report.Rows[3].Errors, ";"), "repeats row 1") {
+		t.Errorf("expected the duplicate to name row 1, got %v", report.Rows[3].Errors)
+	}
+	if report.Total != 5 || report.Issued != 1 || report.Existing != 1 || report.Invalid != 2 || report.Failed != 1 {
+		t.Errorf("unexpected totals %+v", report)
+	}
+}
@@ -0,0 +1,266 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+const (
+	documentCachePrefix = "sih:doc:"
+	staleCachePrefix    = "sih:stale:"
+	eventClaimPrefix    = "sih:event:"
+)
+
+var (
+	documentCache *redisClient
+	cacheTTL      time.Duration
+	staleCacheTTL time.Duration
+
+	cacheHits   atomic.Uint64
+	cacheMisses atomic.Uint64
+	cacheErrors atomic.Uint64
+	cacheStale  atomic.Uint64
+)
+
+// CacheStats reports read-through cache effectiveness
+type CacheStats struct {
+	Enabled bool    `json:"enabled"`
+	Hits    uint64  `json:"hits"`
+	Misses  uint64  `json:"misses"`
+	Errors  uint64  `json:"errors"`
+	Stale   uint64  `json:"stale_served"`
+	HitRate float64 `json:"hit_rate"`
+}
+
+// initDocumentCache enables the Redis read-through cache when REDIS_URL is set
+func initDocumentCache() {
+	rawURL := getEnv("REDIS_URL", "")
+	if rawURL == "" {
+		log.Println("🧊 REDIS_URL not set, document cache disabled")
+		return
+	}
+
+	redis, err := newRedisClient(rawURL, getEnv("REDIS_MODE", redisStandalone), getEnvInt("REDIS_POOL_SIZE", 16),
+		getEnvDuration("REDIS_TIMEOUT", 500*time.Millisecond), getEnv("REDIS_TLS_CA_FILE", ""))
+	if err != nil {
+		panic(fmt.Errorf("failed to configure document cache: %w", err))
+	}
+	documentCache = redis
+	cacheTTL = getEnvDuration("CACHE_TTL", 10*time.Minute)
+	staleCacheTTL = getEnvDuration("CACHE_STALE_TTL", 24*time.Hour)
+	log.Printf("🧊 Caching ledger documents in Redis at %s (ttl %s)", redis.addr, cacheTTL)
+}
+
+// documentCacheKey namespaces id by the request's channel and chaincode, so targets that share a
+// Redis never serve each other's documents
+func documentCacheKey(ctx context.Context, prefix, id string) string {
+	target := targetFromContext(ctx)
+	return prefix + target.Channel + "/" + target.Chaincode + ":" + id
+}
+
+// readDocument evaluates a single-document read, serving it from the cache when possible. Cache
+// failures are never fatal; the ledger remains the source of truth. While Fabric is unreachable the
+// last copy read from the ledger is served instead of an error.
+func readDocument(ctx context.Context, transactionName, id string) ([]byte, error) {
+	if documentCache == nil {
+		return evaluateTransaction(ctx, transactionName, id)
+	}
+
+	key := documentCacheKey(ctx, documentCachePrefix, id)
+	cached, err := documentCache.Get(ctx, key)
+	switch {
+	case err == nil:
+		cacheHits.Add(1)
+		return cached, nil
+	case errors.Is(err, errRedisNil):
+		cacheMisses.Add(1)
+	default:
+		cacheErrors.Add(1)
+		logWithContext(ctx, "Document cache read failed for %s: %v", key, err)
+	}
+
+	result, err := evaluateTransaction(ctx, transactionName, id)
+	if err != nil {
+		if errors.Is(err, errCircuitOpen) || isPeerFailure(err) {
+			if stale, staleErr := documentCache.Get(ctx, documentCacheKey(ctx, staleCachePrefix, id)); staleErr == nil {
+				cacheStale.Add(1)
+				logWithContext(ctx, "Serving stale %s for %s: %v", transactionName, id, err)
+				return stale, nil
+			}
+		}
+		return nil, err
+	}
+	if err := documentCache.Set(ctx, key, result, cacheTTL); err != nil {
+		cacheErrors.Add(1)
+		logWithContext(ctx, "Document cache write failed for %s: %v", key, err)
+	}
+	if err := documentCache.Set(ctx, documentCacheKey(ctx, staleCachePrefix, id), result, staleCacheTTL); err != nil {
+		cacheErrors.Add(1)
+	}
+	return result, nil
+}
+
+// invalidateDocuments drops cached copies of the given document IDs
+func invalidateDocuments(ctx context.Context, ids ...string) {
+	if documentCache == nil || len(ids) == 0 {
+		return
+	}
+
+	keys := make([]string, 0, 2*len(ids))
+	for _, id := range ids {
+		if id != "" {
+			keys = append(keys, documentCacheKey(ctx, documentCachePrefix, id), documentCacheKey(ctx, staleCachePrefix, id))
+		}
+	}
+	if len(keys) == 0 {
+		return
+	}
+	if err := documentCache.Del(ctx, keys...); err != nil {
+		cacheErrors.Add(1)
+		logWithContext(ctx, "Document cache invalidation failed for %v: %v", keys, err)
+	}
+}
+
+// invalidateFromEvent evicts the document named in a chaincode event so writes made through other
+// gateway instances, or directly with the peer CLI, are not served stale. ctx carries the target
+// the event was received from.
+func invalidateFromEvent(ctx context.Context, event *client.ChaincodeEvent) {
+	if documentCache == nil {
+		return
+	}
+	if event.EventName == "BatchIssueDID" {
+		var dids []struct {
+			DigitalID string `json:"digital_id"`
+		}
+		if err := json.Unmarshal(event.Payload, &dids); err == nil {
+			ids := make([]string, 0, len(dids))
+			for _, did := range dids {
+				ids = append(ids, did.DigitalID)
+			}
+			invalidateDocuments(ctx, ids...)
+		}
+		return
+	}
+	if event.EventName == "MergeIncidents" {
+		var incidents []struct {
+			IncidentID string `json:"incident_id"`
+		}
+		if err := json.Unmarshal(event.Payload, &incidents); err == nil {
+			ids := make([]string, 0, len(incidents))
+			for _, incident := range incidents {
+				ids = append(ids, incident.IncidentID)
+			}
+			invalidateDocuments(ctx, ids...)
+		}
+		return
+	}
+	if event.EventName == "ScoreZones" {
+		var zones []struct {
+			ZoneID string `json:"zone_id"`
+		}
+		if err := json.Unmarshal(event.Payload, &zones); err == nil {
+			ids := make([]string, 0, len(zones))
+			for _, zone := range zones {
+				ids = append(ids, zone.ZoneID)
+			}
+			invalidateDocuments(ctx, ids...)
+		}
+		return
+	}
+
+	var ids struct {
+		DigitalID  string `json:"digital_id"`
+		IncidentID string `json:"incident_id"`
+		EvidenceID string `json:"evidence_id"`
+		ZoneID     string `json:"zone_id"`
+		ConsentID  string `json:"consent_id"`
+		DeviceID   string `json:"device_id"`
+		GrantID    string `json:"grant_id"`
+		PermitID   string `json:"permit_id"`
+		ItemID     string `json:"item_id"`
+		LostID     string `json:"lost_item_id"`
+		FoundID    string `json:"found_item_id"`
+	}
+	if err := json.Unmarshal(event.Payload, &ids); err != nil {
+		return
+	}
+
+	switch event.EventName {
+	case "UpdateDID", "DeleteDID", "CreateDID":
+		invalidateDocuments(ctx, ids.DigitalID)
+	case "UpdateIncident", "UpdateIncidentStatus", "DeleteIncident", "CreateIncident":
+		invalidateDocuments(ctx, ids.IncidentID)
+	case "UpdateEvidence", "DeleteEvidence", "CreateEvidence":
+		invalidateDocuments(ctx, ids.EvidenceID)
+	case "UpdateZone", "DeleteZone", "CreateZone":
+		invalidateDocuments(ctx, ids.ZoneID)
+	case "GrantConsent", "RenewConsent", "WithdrawConsent":
+		invalidateDocuments(ctx, ids.ConsentID)
+	case "RegisterDevice", "BindDevice", "UnbindDevice":
+		invalidateDocuments(ctx, ids.DeviceID)
+	case "RevokeOrgAccess":
+		invalidateDocuments(ctx, ids.GrantID)
+	case "RevokePermit":
+		invalidateDocuments(ctx, ids.PermitID)
+	case "AddLostFoundPhoto", "CloseLostFoundItem":
+		invalidateDocuments(ctx, ids.ItemID)
+	case "ResolveLostFoundMatch":
+		invalidateDocuments(ctx, ids.LostID, ids.FoundID)
+	}
+}
+
+// claimEvent reports whether this instance is the first to act on a chaincode event. Every gateway
+// instance sees every event, so with Redis the first SET NX wins; without Redis there is only one
+// instance and it sees each event once.
+func claimEvent(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	if documentCache == nil {
+		return true, nil
+	}
+	_, err := documentCache.Do(ctx, "SET", eventClaimPrefix+key, "1", "NX", "PX", fmt.Sprint(ttl.Milliseconds()))
+	if errors.Is(err, errRedisNil) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func documentCacheStats() CacheStats {
+	stats := CacheStats{
+		Enabled: documentCache != nil,
+		Hits:    cacheHits.Load(),
+		Misses:  cacheMisses.Load(),
+		Errors:  cacheErrors.Load(),
+		Stale:   cacheStale.Load(),
+	}
+	if total := stats.Hits + stats.Misses; total > 0 {
+		stats.HitRate = float64(stats.Hits) / float64(total)
+	}
+	return stats
+}
+
+// checkDocumentCache reports Redis reachability; a cache outage degrades but does not fail the service
+func checkDocumentCache(ctx context.Context) DependencyStatus {
+	if documentCache == nil {
+		return DependencyStatus{Status: statusOK, Detail: "disabled"}
+	}
+
+	start := time.Now()
+	err := documentCache.Ping(ctx)
+	latency := time.Since(start).Milliseconds()
+	if err != nil {
+		return DependencyStatus{Status: statusDegraded, LatencyMS: latency, Error: err.Error()}
+	}
+	return DependencyStatus{Status: statusOK, LatencyMS: latency}
+}
@@ -0,0 +1,548 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+const (
+	canaryStateKeyPrefix = "sih:canary:"
+	defaultCanaryRefresh = 5 * time.Second
+	// canaryMinCalls is how many canary calls an automatic rollback waits for by default
+	canaryMinCalls = 50
+
+	canaryStable = "stable"
+	canaryCanary = "canary"
+
+	errCodeCanaryDisabled = "CANARY_DISABLED"
+)
+
+// canaryStableRoutes stay on the stable chaincode even for reads. The canary keeps its own world
+// state, so SOS alerts, incidents and the records responders act on are only ever read and written
+// where every other caller sees them.
+var canaryStableRoutes = []string{
+	"/graphql",
+	"/api/v1/sos",
+	"/api/v1/incident",
+	"/api/v1/efir",
+	"/api/v1/evidence",
+	"/api/v1/dispatch",
+	"/api/v1/missing-persons",
+}
+
+// canaryRoute reports whether an HTTP request may be served by the canary: only reads, and none
+// of the safety routes
+func canaryRoute(method, route string) bool {
+	if method != http.MethodGet && method != http.MethodHead {
+		return false
+	}
+	return !slices.ContainsFunc(canaryStableRoutes, func(prefix string) bool {
+		return route == prefix || strings.HasPrefix(route, prefix+"/")
+	})
+}
+
+// canaryRPC does the same for a gRPC method, such as /sih.v1.LedgerService/GetDID. Streams write
+// locations, and incident and evidence RPCs are safety records.
+func canaryRPC(fullMethod string, stream bool) bool {
+	_, method, _ := strings.Cut(strings.TrimPrefix(fullMethod, "/"), "/")
+	if stream || strings.Contains(method, "Incident") || strings.Contains(method, "Evidence") {
+		return false
+	}
+	for _, read := range []string{"Get", "Verify", "List", "Search"} {
+		if strings.HasPrefix(method, read) {
+			return true
+		}
+	}
+	return false
+}
+
+// CanaryState is how traffic is split between the stable and canary chaincode. Callers of the
+// listed tenants always reach the canary; of the rest, Percent of callers do.
+type CanaryState struct {
+	Percent   int      `json:"percent"`
+	Tenants   []string `json:"tenants"`
+	UpdatedBy string   `json:"updated_by,omitempty"`
+	UpdatedAt string   `json:"updated_at,omitempty"`
+	// RollbackReason is set by a rollback until the split is changed again
+	RollbackReason string `json:"rollback_reason,omitempty"`
+}
+
+// UpdateCanaryRequest changes the split. Fields left out keep their value.
+type UpdateCanaryRequest struct {
+	Percent *int     `json:"percent"`
+	Tenants []string `json:"tenants"`
+	Actor   string   `json:"actor" binding:"required"`
+}
+
+func (r UpdateCanaryRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	if r.Percent != nil && (*r.Percent < 0 || *r.Percent > 100) {
+		v.add("percent", "must be between 0 and 100")
+	}
+	for i, tenant := range r.Tenants {
+		v.identifier(fmt.Sprintf("tenants[%d]", i), tenant)
+	}
+	v.identifier("actor", r.Actor)
+	return v.errors
+}
+
+// RollbackCanaryRequest sends all traffic back to the stable chaincode
+type RollbackCanaryRequest struct {
+	Actor  string `json:"actor" binding:"required"`
+	Reason string `json:"reason"`
+}
+
+func (r RollbackCanaryRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.identifier("actor", r.Actor)
+	if len(r.Reason) > 500 {
+		v.add("reason", "must be at most 500 characters")
+	}
+	return v.errors
+}
+
+// CanaryVersion is the calls one side of the split made since the split last changed. Errors
+// include chaincode refusals; faults are the errors that say the network or chaincode failed.
+type CanaryVersion struct {
+	Version   string        `json:"version"`
+	Target    string        `json:"target"`
+	Channel   string        `json:"channel"`
+	Chaincode string        `json:"chaincode"`
+	Calls     uint64        `json:"calls"`
+	Errors    uint64        `json:"errors"`
+	Faults    uint64        `json:"faults"`
+	ErrorRate float64       `json:"error_rate"`
+	Latency   CommitLatency `json:"latency"`
+}
+
+// CanaryStatus is the current split and how each side has fared under it
+type CanaryStatus struct {
+	CanaryState
+	Since    string          `json:"since"`
+	Versions []CanaryVersion `json:"versions"`
+}
+
+// canaryStore shares the split between gateway replicas
+type canaryStore interface {
+	load(ctx context.Context) (*CanaryState, error)
+	save(ctx context.Context, state CanaryState) error
+}
+
+type redisCanaryStore struct {
+	redis *redisClient
+	key   string
+}
+
+func (s redisCanaryStore) load(ctx context.Context) (*CanaryState, error) {
+	data, err := s.redis.Get(ctx, s.key)
+	if errors.Is(err, errRedisNil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state CanaryState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+func (s redisCanaryStore) save(ctx context.Context, state CanaryState) error {
+	data, _ := json.Marshal(state)
+	_, err := s.redis.Do(ctx, "SET", s.key, string(data))
+	return err
+}
+
+type memoryCanaryStore struct {
+	mu    sync.Mutex
+	state *CanaryState
+}
+
+func (s *memoryCanaryStore) load(context.Context) (*CanaryState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state, nil
+}
+
+func (s *memoryCanaryStore) save(_ context.Context, state CanaryState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state = &state
+	return nil
+}
+
+type canaryCalls struct {
+	calls, errors, faults uint64
+	latency               latencyHistogram
+}
+
+// canaryRouter sends a share of the default target's API traffic to a second chaincode, so a
+// contract upgrade is tried on some callers before all of them. A caller stays on the same side
+// while the split is unchanged.
+type canaryRouter struct {
+	stable  *fabricTarget
+	canary  *fabricTarget
+	store   canaryStore
+	refresh time.Duration
+	// rollbackErrorRate rolls the canary back when its error rate exceeds the stable rate by more
+	// than this, once it has made minCalls calls; 0 disables automatic rollback
+	rollbackErrorRate float64
+	// rollbackLatencyRatio rolls it back when its p95 latency exceeds stable's this many times
+	// over, once both sides have made minCalls calls; 0 disables it
+	rollbackLatencyRatio float64
+	minCalls             uint64
+
+	mu    sync.RWMutex
+	state CanaryState
+	since time.Time
+	stats map[string]*canaryCalls
+}
+
+var canary *canaryRouter
+
+// initCanary registers CANARY_CHAINCODE as a target beside the default one and starts splitting
+// traffic to it. Runs after initDocumentCache so replicas share the split through Redis.
+func initCanary() {
+	raw := getEnv("CANARY_CHAINCODE", "")
+	if raw == "" {
+		return
+	}
+	stable := defaultTarget
+	channel, chaincode, ok := strings.Cut(raw, "/")
+	if !ok {
+		channel, chaincode = stable.Channel, raw
+	}
+	if channel == "" || chaincode == "" || (channel == stable.Channel && chaincode == stable.Chaincode) {
+		panic(fmt.Errorf("CANARY_CHAINCODE must name a chaincode or channel/chaincode other than the default target's, not %q", raw))
+	}
+	name := getEnv("CANARY_TARGET_NAME", stable.Name+"-canary")
+	if _, exists := fabricTargets[name]; exists {
+		panic(fmt.Errorf("canary target %q is already in FABRIC_TARGETS", name))
+	}
+	target := &fabricTarget{Name: name, Channel: channel, Chaincode: chaincode}
+	target.network = gateway.GetNetwork(channel)
+	target.contract = target.network.GetContract(chaincode)
+	fabricTargets[name] = target
+
+	var store canaryStore = &memoryCanaryStore{}
+	if documentCache != nil {
+		store = redisCanaryStore{redis: documentCache, key: canaryStateKeyPrefix + stable.Name + ":" + channel + "/" + chaincode}
+	}
+	r := newCanaryRouter(stable, target, store)
+	r.refresh = getEnvDuration("CANARY_REFRESH_INTERVAL", defaultCanaryRefresh)
+	r.rollbackErrorRate = getEnvFloat("CANARY_ROLLBACK_ERROR_RATE", 0)
+	r.rollbackLatencyRatio = getEnvFloat("CANARY_ROLLBACK_LATENCY_RATIO", 0)
+	if r.rollbackLatencyRatio != 0 && r.rollbackLatencyRatio <= 1 {
+		panic(fmt.Errorf("CANARY_ROLLBACK_LATENCY_RATIO must be above 1, not %g", r.rollbackLatencyRatio))
+	}
+	r.minCalls = uint64(getEnvInt("CANARY_MIN_CALLS", canaryMinCalls))
+
+	// The environment only seeds the split; one already shared by the replicas, such as a
+	// rollback, wins
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	shared, err := store.load(ctx)
+	if err != nil {
+		panic(fmt.Errorf("failed to load canary split: %w", err))
+	}
+	if shared == nil {
+		seed := CanaryState{Percent: getEnvInt("CANARY_PERCENT", 0), Tenants: splitList(getEnv("CANARY_TENANTS", "")), UpdatedBy: "environment", UpdatedAt: time.Now().UTC().Format(time.RFC3339)}
+		if seed.Percent < 0 || seed.Percent > 100 {
+			panic(fmt.Errorf("CANARY_PERCENT must be between 0 and 100, not %d", seed.Percent))
+		}
+		if err := store.save(ctx, seed); err != nil {
+			panic(fmt.Errorf("failed to save canary split: %w", err))
+		}
+		shared = &seed
+	}
+	r.apply(*shared)
+	canary = r
+	log.Printf("🐤 Canary %s (%s/%s) takes %d%% of %s's traffic and tenants %v", name, channel, chaincode, shared.Percent, stable.Name, shared.Tenants)
+}
+
+func newCanaryRouter(stable, target *fabricTarget, store canaryStore) *canaryRouter {
+	return &canaryRouter{stable: stable, canary: target, store: store, refresh: defaultCanaryRefresh, minCalls: canaryMinCalls,
+		since: time.Now(), stats: map[string]*canaryCalls{canaryStable: {}, canaryCanary: {}}}
+}
+
+// apply takes a split as current. A changed split starts the comparison afresh.
+func (r *canaryRouter) apply(state CanaryState) {
+	if state.Tenants == nil {
+		state.Tenants = []string{}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if state.Percent == r.state.Percent && slices.Equal(state.Tenants, r.state.Tenants) {
+		r.state = state
+		return
+	}
+	r.state, r.since = state, time.Now()
+	r.stats = map[string]*canaryCalls{canaryStable: {}, canaryCanary: {}}
+}
+
+// targetFor picks the side of the split a request to the stable target goes to. Requests to
+// another target are left where they are. Callers check canaryRoute or canaryRPC first.
+func (r *canaryRouter) targetFor(ctx context.Context, caller string) *fabricTarget {
+	target := targetFromContext(ctx)
+	if target != r.stable {
+		return target
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if slices.Contains(r.state.Tenants, callerOrg(ctx)) {
+		return r.canary
+	}
+	h := fnv.New32a()
+	h.Write([]byte(caller))
+	if int(h.Sum32()%100) < r.state.Percent {
+		return r.canary
+	}
+	return target
+}
+
+// observe records a chaincode call made on either side of the split
+func (r *canaryRouter) observe(target *fabricTarget, err error, elapsed time.Duration) {
+	version := canaryStable
+	switch target {
+	case r.canary:
+		version = canaryCanary
+	case r.stable:
+	default:
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	calls := r.stats[version]
+	calls.calls++
+	calls.latency.observe(elapsed.Seconds())
+	if err != nil {
+		calls.errors++
+		if translateFabricError(err).Status >= http.StatusInternalServerError {
+			calls.faults++
+		}
+	}
+}
+
+func (r *canaryRouter) status() CanaryStatus {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	status := CanaryStatus{CanaryState: r.state, Since: r.since.UTC().Format(time.RFC3339)}
+	for _, side := range []struct {
+		version string
+		target  *fabricTarget
+	}{{canaryStable, r.stable}, {canaryCanary, r.canary}} {
+		calls := r.stats[side.version]
+		version := CanaryVersion{Version: side.version, Target: side.target.Name, Channel: side.target.Channel, Chaincode: side.target.Chaincode,
+			Calls: calls.calls, Errors: calls.errors, Faults: calls.faults, Latency: calls.latency.summary()}
+		if calls.calls > 0 {
+			version.ErrorRate = math.Round(float64(calls.errors)/float64(calls.calls)*10000) / 10000
+		}
+		status.Versions = append(status.Versions, version)
+	}
+	return status
+}
+
+// update saves a new split for every replica and applies it here at once
+func (r *canaryRouter) update(ctx context.Context, state CanaryState) error {
+	state.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	if err := r.store.save(ctx, state); err != nil {
+		return err
+	}
+	r.apply(state)
+	return nil
+}
+
+// rollback sends every caller back to the stable chaincode
+func (r *canaryRouter) rollback(ctx context.Context, actor, reason string) error {
+	if reason == "" {
+		reason = "rolled back"
+	}
+	return r.update(ctx, CanaryState{UpdatedBy: actor, RollbackReason: reason})
+}
+
+// unhealthy reports why the canary should be rolled back, or "" while it keeps up with stable
+func (r *canaryRouter) unhealthy() string {
+	if r.rollbackErrorRate <= 0 && r.rollbackLatencyRatio <= 0 {
+		return ""
+	}
+	status := r.status()
+	if status.Percent == 0 && len(status.Tenants) == 0 {
+		return ""
+	}
+	stable, candidate := status.Versions[0], status.Versions[1]
+	if candidate.Calls < r.minCalls {
+		return ""
+	}
+	if r.rollbackErrorRate > 0 && candidate.ErrorRate-stable.ErrorRate > r.rollbackErrorRate {
+		return fmt.Sprintf("error rate %.4f against %.4f on stable", candidate.ErrorRate, stable.ErrorRate)
+	}
+	if r.rollbackLatencyRatio > 0 && stable.Calls >= r.minCalls && stable.Latency.P95MS > 0 &&
+		candidate.Latency.P95MS > stable.Latency.P95MS*r.rollbackLatencyRatio {
+		return fmt.Sprintf("p95 latency %.0fms against %.0fms on stable", candidate.Latency.P95MS, stable.Latency.P95MS)
+	}
+	return ""
+}
+
+// run picks up splits other replicas saved and rolls the canary back automatically when it
+// errs too much more, or is too much slower, than stable
+func (r *canaryRouter) run(ctx context.Context) {
+	ticker := time.NewTicker(r.refresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if reason := r.unhealthy(); reason != "" {
+			log.Printf("🐤 Rolling canary %s back: %s", r.canary.Name, reason)
+			if err := r.rollback(ctx, "gateway", "automatic: "+reason); err != nil {
+				log.Printf("🐤 Failed to roll canary back: %v", err)
+			}
+			continue
+		}
+		state, err := r.store.load(ctx)
+		if err != nil {
+			log.Printf("🐤 Failed to refresh canary split: %v", err)
+			continue
+		}
+		if state != nil {
+			r.apply(*state)
+		}
+	}
+}
+
+// writeMetrics adds the per-version call counts to the Prometheus exposition
+func (r *canaryRouter) writeMetrics(b *strings.Builder) {
+	status := r.status()
+	fmt.Fprintf(b, "# HELP sih_canary_percent Share of callers routed to the canary chaincode.\n# TYPE sih_canary_percent gauge\n")
+	fmt.Fprintf(b, "sih_canary_percent{target=%s} %d\n", metricLabel(r.canary.Name), status.Percent)
+	fmt.Fprintf(b, "# HELP sih_canary_calls_total Chaincode calls on each side of the canary split since it last changed.\n# TYPE sih_canary_calls_total counter\n")
+	for _, v := range status.Versions {
+		fmt.Fprintf(b, "sih_canary_calls_total{version=%s,target=%s} %d\n", metricLabel(v.Version), metricLabel(v.Target), v.Calls)
+	}
+	fmt.Fprintf(b, "# HELP sih_canary_call_errors_total Failed chaincode calls on each side of the canary split.\n# TYPE sih_canary_call_errors_total counter\n")
+	for _, v := range status.Versions {
+		fmt.Fprintf(b, "sih_canary_call_errors_total{version=%s,target=%s} %d\n", metricLabel(v.Version), metricLabel(v.Target), v.Errors)
+	}
+}
+
+// canaryMiddleware moves reads that did not choose a target onto the canary when the split says
+// so. Runs after the signer middleware, which identifies the caller and its tenant.
+func canaryMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if canary == nil || c.GetHeader(targetHeader) != "" || !canaryRoute(c.Request.Method, c.FullPath()) {
+			c.Next()
+			return
+		}
+		ctx := c.Request.Context()
+		caller := apiActor(ctx, c.GetHeader(apiKeyHeader), c.GetHeader("Authorization"), c.ClientIP())
+		if target := canary.targetFor(ctx, caller); target != targetFromContext(ctx) {
+			c.Header(targetHeader, target.Name)
+			c.Request = c.Request.WithContext(withTarget(ctx, target))
+		}
+		c.Next()
+	}
+}
+
+// grpcCanaryInterceptor does the same for RPCs that did not set x-fabric-target
+func grpcCanaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	return handler(grpcCanaryContext(ctx, info.FullMethod, false), req)
+}
+
+func grpcCanaryStreamInterceptor(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return handler(srv, contextStream{stream, grpcCanaryContext(stream.Context(), info.FullMethod, true)})
+}
+
+func grpcCanaryContext(ctx context.Context, fullMethod string, stream bool) context.Context {
+	if canary == nil || !canaryRPC(fullMethod, stream) {
+		return ctx
+	}
+	var apiKey, authorization, clientIP string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if len(md.Get(targetHeader)) > 0 {
+			return ctx
+		}
+		if values := md.Get(apiKeyHeader); len(values) > 0 {
+			apiKey = values[0]
+		}
+		if values := md.Get("authorization"); len(values) > 0 {
+			authorization = values[0]
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		clientIP, _, _ = net.SplitHostPort(p.Addr.String())
+	}
+	return withTarget(ctx, canary.targetFor(ctx, apiActor(ctx, apiKey, authorization, clientIP)))
+}
+
+func canaryEnabled(c *gin.Context) bool {
+	if canary == nil {
+		respondError(c, http.StatusServiceUnavailable, errCodeCanaryDisabled, "Canary routing is not configured; set CANARY_CHAINCODE")
+		return false
+	}
+	return true
+}
+
+func getCanary(c *gin.Context) {
+	if !canaryEnabled(c) {
+		return
+	}
+	respondData(c, http.StatusOK, canary.status())
+}
+
+func updateCanary(c *gin.Context) {
+	var req UpdateCanaryRequest
+	if !bindRequest(c, &req) || !canaryEnabled(c) {
+		return
+	}
+	state := canary.status().CanaryState
+	if req.Percent != nil {
+		state.Percent = *req.Percent
+	}
+	if req.Tenants != nil {
+		state.Tenants = req.Tenants
+	}
+	state.UpdatedBy, state.RollbackReason = req.Actor, ""
+	if err := canary.update(c.Request.Context(), state); err != nil {
+		respondServiceError(c, "Failed to save canary split", err)
+		return
+	}
+	respondData(c, http.StatusOK, canary.status())
+}
+
+func rollbackCanary(c *gin.Context) {
+	var req RollbackCanaryRequest
+	if !bindRequest(c, &req) || !canaryEnabled(c) {
+		return
+	}
+	if err := canary.rollback(c.Request.Context(), req.Actor, req.Reason); err != nil {
+		respondServiceError(c, "Failed to roll canary back", err)
+		return
+	}
+	log.Printf("🐤 Canary %s rolled back by %s", canary.canary.Name, req.Actor)
+	respondData(c, http.StatusOK, canary.status())
+}
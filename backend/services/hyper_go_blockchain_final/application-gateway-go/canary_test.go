@@ -0,0 +1,238 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCanaryRouting(t *testing.T) {
+	stable := &fabricTarget{Name: "default", Channel: "mychannel", Chaincode: "basic"}
+	next := &fabricTarget{Name: "default-canary", Channel: "mychannel", Chaincode: "basic-v2"}
+	other := &fabricTarget{Name: "identity", Channel: "identity-channel", Chaincode: "sihcc"}
+	previous := defaultTarget
+	defaultTarget = stable
+	defer func() { defaultTarget = previous }()
+
+	r := newCanaryRouter(stable, next, &memoryCanaryStore{})
+	ctx := context.Background()
+	r.apply(CanaryState{Percent: 20})
+
+	routed := 0
+	for i := 0; i < 1000; i++ {
+		caller := fmt.Sprintf("wallet:tourist-%d", i)
+		target := r.targetFor(ctx, caller)
+		if target != r.targetFor(ctx, caller) {
+			t.Fatalf("expected %s kept on one side", caller)
+		}
+		if target == next {
+			routed++
+		}
+	}
+	if routed < 150 || routed > 250 {
+		t.Errorf("expected about a fifth of callers on the canary, got %d of 1000", routed)
+	}
+	if got := r.targetFor(withTarget(ctx, other), "wallet:tourist-1"); got != other {
+		t.Errorf("expected a request to another target left alone, got %s", got.Name)
+	}
+
+	r.apply(CanaryState{Tenants: []string{"Org2MSP"}})
+	if got := r.targetFor(withSigner(ctx, &walletIdentity{Label: "police", MSPID: "Org2MSP"}), "wallet:police"); got != next {
+		t.Errorf("expected the listed tenant on the canary, got %s", got.Name)
+	}
+	if got := r.targetFor(withSigner(ctx, &walletIdentity{Label: "clerk", MSPID: "Org1MSP"}), "wallet:clerk"); got != stable {
+		t.Errorf("expected other tenants on stable at 0%%, got %s", got.Name)
+	}
+}
+
+func TestCanaryRollback(t *testing.T) {
+	stable := &fabricTarget{Name: "default", Channel: "mychannel", Chaincode: "basic"}
+	next := &fabricTarget{Name: "default-canary", Channel: "mychannel", Chaincode: "basic-v2"}
+	store := &memoryCanaryStore{}
+	r := newCanaryRouter(stable, next, store)
+	r.rollbackErrorRate, r.minCalls = 0.1, 10
+	ctx := context.Background()
+	if err := r.update(ctx, CanaryState{Percent: 50, UpdatedBy: "release-manager"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 20; i++ {
+		r.observe(stable, nil, 40*time.Millisecond)
+		var err error
+		if i%2 == 0 {
+			err = errors.New("chaincode panicked")
+		}
+		r.observe(next, err, 300*time.Millisecond)
+	}
+	r.observe(&fabricTarget{Name: "identity"}, nil, time.Millisecond)
+	status := r.status()
+	if status.Versions[0].Calls != 20 || status.Versions[0].ErrorRate != 0 || status.Versions[1].Errors != 10 || status.Versions[1].ErrorRate != 0.5 {
+		t.Fatalf("unexpected comparison %+v", status.Versions)
+	}
+	if status.Versions[1].Latency.Count != 20 || status.Versions[1].Latency.AvgMS != 300 {
+		t.Errorf("expected the canary's latency recorded, got %+v", status.Versions[1].Latency)
+	}
+
+	reason := r.unhealthy()
+	if reason == "" {
+		t.Fatal("expected a canary failing half its calls rolled back")
+	}
+	if err := r.rollback(ctx, "gateway", "automatic: "+reason); err != nil {
+		t.Fatal(err)
+	}
+	shared, _ := store.load(ctx)
+	if shared.Percent != 0 || len(shared.Tenants) != 0 || shared.UpdatedBy != "gateway" || shared.RollbackReason == "" {
+		t.Errorf("expected the rollback shared with the replicas, got %+v", shared)
+	}
+	if status := r.status(); status.Versions[1].Calls != 0 {
+		t.Error("expected the comparison started afresh")
+	}
+	if r.unhealthy() != "" {
+		t.Error("expected a rolled back canary left alone")
+	}
+}
+
+func TestCanaryLatencyRollback(t *testing.T) {
+	stable := &fabricTarget{Name: "default", Channel: "mychannel", Chaincode: "basic"}
+	next := &fabricTarget{Name: "default-canary", Channel: "mychannel", Chaincode: "basic-v2"}
+	r := newCanaryRouter(stable, next, &memoryCanaryStore{})
+	r.rollbackLatencyRatio, r.minCalls = 2, 10
+	r.apply(CanaryState{Percent: 50})
+
+	for i := 0; i < 10; i++ {
+		r.observe(next, nil, 400*time.Millisecond)
+	}
+	if reason := r.unhealthy(); reason != "" {
+		t.Errorf("expected no comparison before stable made enough calls, got %q", reason)
+	}
+	for i := 0; i < 10; i++ {
+		r.observe(stable, nil, 50*time.Millisecond)
+	}
+	if reason := r.unhealthy(); !strings.Contains(reason, "p95 latency") {
+		t.Errorf("expected a canary eight times slower rolled back, got %q", reason)
+	}
+	r.rollbackLatencyRatio = 10
+	if reason := r.unhealthy(); reason != "" {
+		t.Errorf("expected a slowdown within the ratio tolerated, got %q", reason)
+	}
+}
+
+func TestCanaryRoutes(t *testing.T) {
+	for _, tc := range []struct {
+		method, route string
+		canary        bool
+	}{
+		{http.MethodGet, "/api/v1/did/:id", true},
+		{http.MethodGet, "/api/v1/geofence/zones", true},
+		{http.MethodPost, "/api/v1/did/", false},
+		{http.MethodPut, "/api/v1/did/:id", false},
+		{http.MethodPost, "/api/v1/sos", false},
+		{http.MethodGet, "/api/v1/sos/:id/trigger", false},
+		{http.MethodPost, "/api/v1/incident/", false},
+		{http.MethodGet, "/api/v1/incident/:id", false},
+		{http.MethodGet, "/api/v1/dispatch/units", false},
+		{http.MethodGet, "/api/v1/evidence/:id", false},
+		{http.MethodGet, "/graphql", false},
+		{http.MethodGet, "/api/v1/sosx", true},
+	} {
+		if got := canaryRoute(tc.method, tc.route); got != tc.canary {
+			t.Errorf("%s %s: expected canary %v", tc.method, tc.route, tc.canary)
+		}
+	}
+	for _, tc := range []struct {
+		method string
+		stream bool
+		canary bool
+	}{
+		{"/sih.v1.LedgerService/GetDID", false, true},
+		{"/sih.v1.LedgerService/VerifyDID", false, true},
+		{"/sih.v1.LedgerService/SearchAudits", false, true},
+		{"/sih.v1.LedgerService/CreateDID", false, false},
+		{"/sih.v1.LedgerService/GetIncident", false, false},
+		{"/sih.v1.LedgerService/ListEvidenceByIncident", false, false},
+		{"/sih.v1.LocationService/StreamLocation", true, false},
+	} {
+		if got := canaryRPC(tc.method, tc.stream); got != tc.canary {
+			t.Errorf("%s: expected canary %v", tc.method, tc.canary)
+		}
+	}
+}
+
+func TestCanaryMiddleware(t *testing.T) {
+	stable := &fabricTarget{Name: "default", Channel: "mychannel", Chaincode: "basic"}
+	next := &fabricTarget{Name: "default-canary", Channel: "mychannel", Chaincode: "basic-v2"}
+	previousTargets, previousDefault := fabricTargets, defaultTarget
+	fabricTargets, defaultTarget = map[string]*fabricTarget{"default": stable, "default-canary": next}, stable
+	canary = newCanaryRouter(stable, next, &memoryCanaryStore{})
+	canary.apply(CanaryState{Percent: 100})
+	defer func() { fabricTargets, defaultTarget, canary = previousTargets, previousDefault, nil }()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/target", targetMiddleware(), canaryMiddleware(), func(c *gin.Context) {
+		c.String(http.StatusOK, targetFromContext(c.Request.Context()).Chaincode)
+	})
+	for header, want := range map[string]string{"": "basic-v2", "default": "basic", "default-canary": "basic-v2"} {
+		req := httptest.NewRequest(http.MethodGet, "/target", nil)
+		if header != "" {
+			req.Header.Set(targetHeader, header)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Body.String() != want {
+			t.Errorf("header %q: expected %s, got %s", header, want, w.Body.String())
+		}
+	}
+
+	// Writes stay on stable whatever the split
+	r.POST("/target", targetMiddleware(), canaryMiddleware(), func(c *gin.Context) {
+		c.String(http.StatusOK, targetFromContext(c.Request.Context()).Chaincode)
+	})
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/target", nil))
+	if w.Body.String() != "basic" {
+		t.Errorf("expected a write kept on stable, got %s", w.Body.String())
+	}
+}
+
+func TestCanaryRedisStore(t *testing.T) {
+	stable := &fabricTarget{Name: "default", Channel: "mychannel", Chaincode: "basic"}
+	next := &fabricTarget{Name: "default-canary", Channel: "mychannel", Chaincode: "basic-v2"}
+	client := startTestRedis(t)
+	key := canaryStateKeyPrefix + "default:mychannel/basic-v2"
+	ctx := context.Background()
+
+	first := newCanaryRouter(stable, next, redisCanaryStore{redis: client, key: key})
+	second := redisCanaryStore{redis: client, key: key}
+	if state, err := second.load(ctx); err != nil || state != nil {
+		t.Fatalf("expected no split before one is saved, got %+v, %v", state, err)
+	}
+
+	if err := first.update(ctx, CanaryState{Percent: 30, Tenants: []string{"Org2MSP"}, UpdatedBy: "release-manager"}); err != nil {
+		t.Fatal(err)
+	}
+	state, err := second.load(ctx)
+	if err != nil || state == nil || state.Percent != 30 || len(state.Tenants) != 1 || state.UpdatedBy != "release-manager" {
+		t.Fatalf("expected the split saved by one replica loaded by another, got %+v, %v", state, err)
+	}
+
+	replica := newCanaryRouter(stable, next, second)
+	replica.apply(*state)
+	if err := first.rollback(ctx, "release-manager", "bad release"); err != nil {
+		t.Fatal(err)
+	}
+	if state, err = second.load(ctx); err != nil || state == nil {
+		t.Fatalf("expected the rollback loaded, got %v", err)
+	}
+	replica.apply(*state)
+	if status := replica.status(); status.Percent != 0 || len(status.Tenants) != 0 || status.RollbackReason != "bad release" {
+		t.Errorf("expected the rollback seen by the other replica, got %+v", status.CanaryState)
+	}
+}
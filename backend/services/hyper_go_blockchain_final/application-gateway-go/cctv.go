@@ -0,0 +1,435 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// cctvMediaTypes are the evidence media types a CCTV clip may be recorded as
+var cctvMediaTypes = []string{"video/mp4", "video/quicktime"}
+
+// CCTVManifestDocument is a CCTV export anchored against an incident
+type CCTVManifestDocument struct {
+	ManifestID   string     `json:"manifest_id"`
+	IncidentID   string     `json:"incident_id"`
+	Source       string     `json:"source"`
+	CameraID     string     `json:"camera_id"`
+	WindowStart  string     `json:"window_start"`
+	WindowEnd    string     `json:"window_end"`
+	Clips        []CCTVClip `json:"clips"`
+	ManifestHash string     `json:"manifest_hash"`
+	AnchoredBy   string     `json:"anchored_by"`
+	AnchoredAt   string     `json:"anchored_at"`
+	OwnerOrg     string     `json:"owner_org,omitempty"`
+	TxID         string     `json:"tx_id"`
+}
+
+// CCTVClip is one clip of an anchored export and the evidence record holding its hash
+type CCTVClip struct {
+	ClipID     string `json:"clip_id"`
+	EvidenceID string `json:"evidence_id"`
+	Hash       string `json:"hash"`
+	StartedAt  string `json:"started_at"`
+	EndedAt    string `json:"ended_at"`
+}
+
+// CCTVManifestRequest is an export manifest from a municipal CCTV system: the camera, the window
+// exported and the SHA-256 of each clip. The footage stays with the municipal system.
+type CCTVManifestRequest struct {
+	ManifestID  string             `json:"manifestID" binding:"required"`
+	IncidentID  string             `json:"incidentID" binding:"required"`
+	Source      string             `json:"source" binding:"required"`
+	CameraID    string             `json:"cameraID" binding:"required"`
+	WindowStart string             `json:"windowStart" binding:"required"`
+	WindowEnd   string             `json:"windowEnd" binding:"required"`
+	Clips       []CCTVManifestClip `json:"clips" binding:"required"`
+	Actor       string             `json:"actor" binding:"required"`
+}
+
+// CCTVManifestClip is a clip as the manifest lists it. MediaType defaults to video/mp4.
+type CCTVManifestClip struct {
+	ClipID    string `json:"clipID" binding:"required"`
+	Hash      string `json:"hash" binding:"required"`
+	StartedAt string `json:"startedAt" binding:"required"`
+	EndedAt   string `json:"endedAt" binding:"required"`
+	MediaType string `json:"mediaType"`
+}
+
+func (r CCTVManifestRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.identifier("manifestID", r.ManifestID)
+	v.identifier("incidentID", r.IncidentID)
+	v.identifier("source", r.Source)
+	v.identifier("cameraID", r.CameraID)
+	v.identifier("actor", r.Actor)
+	start, startOK := v.rfc3339("windowStart", r.WindowStart)
+	end, endOK := v.rfc3339("windowEnd", r.WindowEnd)
+	window := startOK && endOK
+	switch {
+	case !window:
+	case !end.After(start):
+		v.add("windowEnd", "must be after windowStart")
+		window = false
+	case end.Sub(start) > cctv.maxWindow:
+		v.add("windowEnd", "must be within %s of windowStart", cctv.maxWindow)
+	}
+	switch {
+	case len(r.Clips) == 0:
+		v.add("clips", "must list at least one clip")
+	case len(r.Clips) > cctv.maxClips:
+		v.add("clips", "must list at most %d clips", cctv.maxClips)
+	}
+	seen := map[string]bool{}
+	for i, clip := range r.Clips {
+		field := fmt.Sprintf("clips[%d]", i)
+		v.identifier(field+".clipID", clip.ClipID)
+		if seen[clip.ClipID] {
+			v.add(field+".clipID", "must be unique in the manifest")
+		}
+		seen[clip.ClipID] = true
+		if len(r.ManifestID)+1+len(clip.ClipID) > 128 {
+			v.add(field+".clipID", "must leave the evidence ID %s:<clipID> within 128 characters", r.ManifestID)
+		}
+		v.sha256(field+".hash", clip.Hash)
+		if clip.MediaType != "" {
+			v.oneOf(field+".mediaType", clip.MediaType, cctvMediaTypes)
+		}
+		clipStart, startOK := v.rfc3339(field+".startedAt", clip.StartedAt)
+		clipEnd, endOK := v.rfc3339(field+".endedAt", clip.EndedAt)
+		switch {
+		case !startOK || !endOK:
+		case clipEnd.Before(clipStart):
+			v.add(field+".endedAt", "must not be before startedAt")
+		case window && (clipStart.Before(start) || clipEnd.After(end)):
+			v.add(field, "must fall within the manifest's window")
+		}
+	}
+	return v.errors
+}
+
+// clipEvidenceID names the evidence record of a manifest's clip
+func clipEvidenceID(manifestID, clipID string) string {
+	return manifestID + ":" + clipID
+}
+
+// digest is the SHA-256 of the manifest as the municipal system exported it, leaving out who
+// submitted it
+func (r CCTVManifestRequest) digest() string {
+	r.Actor = ""
+	data, _ := json.Marshal(r)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// CCTVClipVerification is the verdict on a clip re-uploaded against its anchored hash
+type CCTVClipVerification struct {
+	ManifestID   string `json:"manifest_id"`
+	ClipID       string `json:"clip_id"`
+	EvidenceID   string `json:"evidence_id"`
+	AnchoredHash string `json:"anchored_hash"`
+	ComputedHash string `json:"computed_hash"`
+	Bytes        int64  `json:"bytes"`
+	Verified     bool   `json:"verified"`
+}
+
+// cctvSettings bounds the manifests the gateway accepts and the clips it verifies
+type cctvSettings struct {
+	maxClips     int
+	maxWindow    time.Duration
+	maxClipBytes int64
+	// concurrency is how many clips' evidence records are submitted at once
+	concurrency int
+}
+
+var cctv = cctvSettings{maxClips: 200, maxWindow: 24 * time.Hour, maxClipBytes: 8 << 30, concurrency: 8}
+
+func initCCTV() {
+	settings := cctvSettings{
+		maxClips:     getEnvInt("CCTV_MAX_CLIPS", 200),
+		maxWindow:    getEnvDuration("CCTV_MAX_WINDOW", 24*time.Hour),
+		maxClipBytes: int64(getEnvInt("CCTV_MAX_CLIP_BYTES", 8<<30)),
+		concurrency:  getEnvInt("CCTV_ANCHOR_CONCURRENCY", 8),
+	}
+	if settings.maxClips < 1 || settings.maxWindow <= 0 || settings.maxClipBytes <= 0 || settings.concurrency < 1 {
+		panic(fmt.Errorf("CCTV_MAX_CLIPS, CCTV_MAX_WINDOW, CCTV_MAX_CLIP_BYTES and CCTV_ANCHOR_CONCURRENCY must be positive"))
+	}
+	cctv = settings
+}
+
+// AnchorCCTVManifest records each clip as evidence on the incident, then anchors the manifest
+// against those records. A resubmitted manifest reuses the clips already recorded, as long as their
+// hashes have not changed.
+func (ledgerService) AnchorCCTVManifest(ctx context.Context, req CCTVManifestRequest) (*TransactionResult, error) {
+	if errs := req.Validate(); len(errs) > 0 {
+		return nil, errs
+	}
+	if _, err := ledger.ownedIncident(ctx, req.IncidentID); err != nil {
+		return nil, err
+	}
+
+	clips := make([]CCTVClip, len(req.Clips))
+	errs := make([]error, len(req.Clips))
+	slots := make(chan struct{}, cctv.concurrency)
+	var wg sync.WaitGroup
+	for i, clip := range req.Clips {
+		clips[i] = CCTVClip{
+			ClipID:     clip.ClipID,
+			EvidenceID: clipEvidenceID(req.ManifestID, clip.ClipID),
+			Hash:       clip.Hash,
+			StartedAt:  clip.StartedAt,
+			EndedAt:    clip.EndedAt,
+		}
+		mediaType := clip.MediaType
+		if mediaType == "" {
+			mediaType = cctvMediaTypes[0]
+		}
+		wg.Add(1)
+		go func(i int, evidence CreateEvidenceRequest) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			errs[i] = recordClipEvidence(ctx, evidence, fmt.Sprintf("clips[%d].hash", i))
+		}(i, CreateEvidenceRequest{
+			EvidenceID:   clips[i].EvidenceID,
+			EvidenceHash: clip.Hash,
+			IncidentID:   req.IncidentID,
+			MediaType:    mediaType,
+			UploadedBy:   req.Source,
+		})
+	}
+	wg.Wait()
+	var mismatched ValidationErrors
+	for _, err := range errs {
+		var clipErrs ValidationErrors
+		switch {
+		case errors.As(err, &clipErrs):
+			mismatched = append(mismatched, clipErrs...)
+		case err != nil:
+			return nil, err
+		}
+	}
+	if len(mismatched) > 0 {
+		return nil, mismatched
+	}
+
+	clipsJSON, err := json.Marshal(clips)
+	if err != nil {
+		return nil, err
+	}
+	return submitTransaction(ctx, "AnchorCCTVManifest", req.ManifestID, req.IncidentID, req.Source, req.CameraID,
+		req.WindowStart, req.WindowEnd, string(clipsJSON), req.digest(), req.Actor)
+}
+
+// recordClipEvidence records a clip's evidence, accepting a record already there from an earlier
+// submission of the same clip
+func recordClipEvidence(ctx context.Context, req CreateEvidenceRequest, field string) error {
+	_, err := ledger.CreateEvidence(ctx, req)
+	if err == nil || translateFabricError(err).Code != errCodeAlreadyExists {
+		return err
+	}
+	existing, err := ledger.GetEvidence(ctx, req.EvidenceID)
+	if err != nil {
+		return err
+	}
+	if existing.IncidentID != req.IncidentID || existing.EvidenceHash != req.EvidenceHash {
+		return ValidationErrors{{Field: field, Message: "does not match the evidence " + req.EvidenceID + " already recorded"}}
+	}
+	return nil
+}
+
+// GetCCTVManifest reads a CCTV manifest the caller's organization anchored
+func (ledgerService) GetCCTVManifest(ctx context.Context, manifestID string) (CCTVManifestDocument, error) {
+	if errs := validateDocumentID("id", manifestID); len(errs) > 0 {
+		return CCTVManifestDocument{}, errs
+	}
+	result, err := readDocument(ctx, "ReadCCTVManifest", manifestID)
+	if err != nil {
+		return CCTVManifestDocument{}, err
+	}
+	manifest, err := decodeDocument[CCTVManifestDocument](result, "CCTV manifest")
+	if err == nil && !tenantFromContext(ctx).owns(manifest.OwnerOrg) {
+		return CCTVManifestDocument{}, fmt.Errorf("the CCTV manifest %s does not exist", manifestID)
+	}
+	return manifest, err
+}
+
+// ListCCTVManifestsByIncident returns the CCTV manifests anchored against an incident, by window start
+func (ledgerService) ListCCTVManifestsByIncident(ctx context.Context, incidentID string) ([]CCTVManifestDocument, error) {
+	if errs := validateDocumentID("id", incidentID); len(errs) > 0 {
+		return nil, errs
+	}
+	result, err := evaluateTransaction(ctx, "GetCCTVManifestsByIncident", incidentID)
+	if err != nil {
+		return nil, err
+	}
+	manifests, err := decodeDocument[[]CCTVManifestDocument](result, "CCTV manifest")
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(manifests, func(i, j int) bool { return manifests[i].WindowStart < manifests[j].WindowStart })
+	return filterOwned(tenantFromContext(ctx), manifests, func(m CCTVManifestDocument) string { return m.OwnerOrg }), nil
+}
+
+// clip returns the anchored clip with the given ID
+func (m CCTVManifestDocument) clip(clipID string) (CCTVClip, bool) {
+	for _, clip := range m.Clips {
+		if clip.ClipID == clipID {
+			return clip, true
+		}
+	}
+	return CCTVClip{}, false
+}
+
+func anchorCCTVManifest(c *gin.Context) {
+	var req CCTVManifestRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+	setAuditTarget(c, req.ManifestID)
+
+	result, err := ledger.AnchorCCTVManifest(c.Request.Context(), req)
+	if err != nil {
+		respondServiceError(c, "Failed to anchor CCTV manifest", err)
+		return
+	}
+	respondCommitted(c, http.StatusCreated, gin.H{
+		"message":    "CCTV manifest anchored successfully",
+		"manifestID": req.ManifestID,
+		"clips":      len(req.Clips),
+	}, result)
+}
+
+func getCCTVManifest(c *gin.Context) {
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	manifest, err := ledger.GetCCTVManifest(c.Request.Context(), id)
+	if err != nil {
+		respondServiceError(c, "Failed to read CCTV manifest", err)
+		return
+	}
+	respondTagged(c, manifest, manifest.TxID)
+}
+
+func getIncidentCCTVManifests(c *gin.Context) {
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	manifests, err := ledger.ListCCTVManifestsByIncident(c.Request.Context(), id)
+	if err != nil {
+		respondServiceError(c, "Failed to get CCTV manifests by incident", err)
+		return
+	}
+	txIDs := make([]string, 0, len(manifests))
+	for _, manifest := range manifests {
+		txIDs = append(txIDs, manifest.TxID)
+	}
+	respondTagged(c, manifests, txIDs...)
+}
+
+// verifyCCTVClip hashes a clip retrieved from the municipal system as it streams in and compares it
+// with the hash anchored in the manifest. Nothing is stored. A mismatch is a verdict, not an error.
+func verifyCCTVClip(c *gin.Context) {
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	clipID := c.Param("clipId")
+	if errs := validateDocumentID("clipId", clipID); len(errs) > 0 {
+		respondValidationErrors(c, errs)
+		return
+	}
+	setAuditTarget(c, id)
+	ctx := c.Request.Context()
+
+	manifest, err := ledger.GetCCTVManifest(ctx, id)
+	if err != nil {
+		respondServiceError(c, "Failed to read CCTV manifest", err)
+		return
+	}
+	clip, ok := manifest.clip(clipID)
+	if !ok {
+		respondError(c, http.StatusNotFound, errCodeNotFound, fmt.Sprintf("The CCTV manifest %s has no clip %s", id, clipID))
+		return
+	}
+
+	limit := cctv.maxClipBytes + uploadFormOverhead
+	if c.Request.ContentLength > limit {
+		respondClipTooLarge(c)
+		return
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		respondValidationErrors(c, ValidationErrors{{Field: "body", Message: "must be multipart/form-data"}})
+		return
+	}
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			respondValidationErrors(c, ValidationErrors{{Field: uploadFileField, Message: "is required"}})
+			return
+		}
+		if err != nil {
+			if isUploadTooLarge(err) {
+				respondClipTooLarge(c)
+				return
+			}
+			respondValidationErrors(c, ValidationErrors{{Field: "body", Message: err.Error()}})
+			return
+		}
+		if part.FormName() != uploadFileField {
+			part.Close()
+			continue
+		}
+		defer part.Close()
+
+		hasher := sha256.New()
+		n, err := io.Copy(hasher, &uploadLimitReader{reader: part, limit: cctv.maxClipBytes})
+		if isUploadTooLarge(err) {
+			respondClipTooLarge(c)
+			return
+		}
+		if err != nil {
+			respondValidationErrors(c, ValidationErrors{{Field: uploadFileField, Message: err.Error()}})
+			return
+		}
+		verdict := CCTVClipVerification{
+			ManifestID:   id,
+			ClipID:       clipID,
+			EvidenceID:   clip.EvidenceID,
+			AnchoredHash: clip.Hash,
+			ComputedHash: hex.EncodeToString(hasher.Sum(nil)),
+			Bytes:        n,
+		}
+		verdict.Verified = verdict.ComputedHash == verdict.AnchoredHash
+		if !verdict.Verified {
+			logWithContext(ctx, "⚠️  CCTV clip %s of %s does not match its anchored hash: ledger=%s computed=%s", clipID, id, clip.Hash, verdict.ComputedHash)
+		}
+		respondData(c, http.StatusOK, verdict)
+		return
+	}
+}
+
+func respondClipTooLarge(c *gin.Context) {
+	respondError(c, http.StatusRequestEntityTooLarge, errCodePayloadTooLarge, fmt.Sprintf("CCTV clip must not exceed %d bytes", cctv.maxClipBytes))
+}
@@ -0,0 +1,74 @@
+package gateway
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCCTVManifestValidate(t *testing.T) {
+	hash := strings.Repeat("ab", 32)
+	valid := CCTVManifestRequest{
+		ManifestID:  "SMC-2025-0920-cam17",
+		IncidentID:  "INC-2025-0042",
+		Source:      "shillong-smart-city",
+		CameraID:    "police-bazar-17",
+		WindowStart: "2025-09-20T12:00:00Z",
+		WindowEnd:   "2025-09-20T13:00:00Z",
+		Clips: []CCTVManifestClip{
+			{ClipID: "0001", Hash: hash, StartedAt: "2025-09-20T12:00:00Z", EndedAt: "2025-09-20T12:30:00Z"},
+			{ClipID: "0002", Hash: hash, StartedAt: "2025-09-20T12:30:00Z", EndedAt: "2025-09-20T13:00:00Z", MediaType: "video/quicktime"},
+		},
+		Actor: "smc-export",
+	}
+	if errs := valid.Validate(); len(errs) > 0 {
+		t.Fatalf("expected a valid manifest, got %v", errs)
+	}
+
+	cases := map[string]func(r *CCTVManifestRequest){
+		"windowEnd":        func(r *CCTVManifestRequest) { r.WindowEnd = r.WindowStart },
+		"clips":            func(r *CCTVManifestRequest) { r.Clips = nil },
+		"clips[1].clipID":  func(r *CCTVManifestRequest) { r.Clips[1].ClipID = "0001" },
+		"clips[0].hash":    func(r *CCTVManifestRequest) { r.Clips[0].Hash = "not-a-hash" },
+		"clips[1]":         func(r *CCTVManifestRequest) { r.Clips[1].EndedAt = "2025-09-20T13:00:01Z" },
+		"clips[0].endedAt": func(r *CCTVManifestRequest) { r.Clips[0].EndedAt = "2025-09-20T11:59:00Z" },
+		// Clips are recorded as evidence, so only video is accepted
+		"clips[1].mediaType": func(r *CCTVManifestRequest) { r.Clips[1].MediaType = "image/jpeg" },
+	}
+	for field, mutate := range cases {
+		req := valid
+		req.Clips = append([]CCTVManifestClip(nil), valid.Clips...)
+		mutate(&req)
+		errs := req.Validate()
+		if len(errs) == 0 || errs[0].Field != field {
+			t.Errorf("expected %s rejected, got %v", field, errs)
+		}
+	}
+
+	long := valid
+	long.ManifestID = strings.Repeat("m", 100)
+	long.Clips = []CCTVManifestClip{{ClipID: strings.Repeat("c", 40), Hash: hash, StartedAt: "2025-09-20T12:00:00Z", EndedAt: "2025-09-20T12:30:00Z"}}
+	if errs := long.Validate(); len(errs) != 1 || errs[0].Field != "clips[0].clipID" {
+		t.Errorf("expected an evidence ID over 128 characters rejected, got %v", errs)
+	}
+}
+
+func TestCCTVManifestDigest(t *testing.T) {
+	req := CCTVManifestRequest{ManifestID: "m1", CameraID: "cam", Clips: []CCTVManifestClip{{ClipID: "0001", Hash: strings.Repeat("a", 64)}}, Actor: "one"}
+	other := req
+	other.Actor = "another"
+	if req.digest() != other.digest() {
+		t.Error("expected the digest to leave out the submitter")
+	}
+	other.Clips = []CCTVManifestClip{{ClipID: "0001", Hash: strings.Repeat("b", 64)}}
+	if req.digest() == other.digest() {
+		t.Error("expected a changed clip hash to change the digest")
+	}
+
+	manifest := CCTVManifestDocument{ManifestID: "m1", Clips: []CCTVClip{{ClipID: "0001", EvidenceID: clipEvidenceID("m1", "0001")}}}
+	if clip, ok := manifest.clip("0001"); !ok || clip.EvidenceID != "m1:0001" {
+		t.Errorf("unexpected clip %+v", clip)
+	}
+	if _, ok := manifest.clip("0002"); ok {
+		t.Error("expected an unknown clip missing")
+	}
+}
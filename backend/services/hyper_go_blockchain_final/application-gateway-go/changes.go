@@ -0,0 +1,387 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+const (
+	maxChangesWait            = 30 * time.Second
+	errCodeChangesUnavailable = "CHANGES_UNAVAILABLE"
+)
+
+// Incident change types
+const (
+	changeStatus   = "status_changed"
+	changeEvidence = "evidence_added"
+	changeEFIR     = "efir_generated"
+	changeDeleted  = "incident_deleted"
+)
+
+// IncidentChange is one event affecting an incident, for clients that long-poll instead of holding
+// a WebSocket
+type IncidentChange struct {
+	Type         string `json:"type"`
+	IncidentID   string `json:"incident_id"`
+	Status       string `json:"status,omitempty"`
+	EvidenceID   string `json:"evidence_id,omitempty"`
+	MediaType    string `json:"media_type,omitempty"`
+	FIRID        string `json:"fir_id,omitempty"`
+	DocumentHash string `json:"document_hash,omitempty"`
+	TxID         string `json:"tx_id"`
+	BlockNumber  uint64 `json:"block_number"`
+	// Cursor resumes the feed after this change
+	Cursor string `json:"cursor"`
+
+	position changeCursor
+	received time.Time
+}
+
+// IncidentChanges answers a poll. Pass Cursor as since on the next poll.
+type IncidentChanges struct {
+	IncidentID string           `json:"incident_id"`
+	Changes    []IncidentChange `json:"changes"`
+	Cursor     string           `json:"cursor"`
+}
+
+type IncidentChangesRequest struct {
+	Since string `form:"since"`
+	// Wait is how long to hold the request open for a change, in seconds
+	Wait int `form:"wait"`
+}
+
+func (r IncidentChangesRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	if r.Since != "" {
+		if _, err := parseChangeCursor(r.Since); err != nil {
+			v.add("since", "must be a cursor returned by a previous poll")
+		}
+	}
+	if r.Wait < 0 || time.Duration(r.Wait)*time.Second > maxChangesWait {
+		v.add("wait", "must be between 1 and %d", int(maxChangesWait/time.Second))
+	}
+	return v.errors
+}
+
+// changeCursor is a position in a channel's chaincode events: count events of block have been seen.
+// Every gateway instance sees the same events in the same order, so cursors work on any of them.
+type changeCursor struct {
+	block uint64
+	count int
+}
+
+func (c changeCursor) String() string {
+	return fmt.Sprintf("%d.%d", c.block, c.count)
+}
+
+func (c changeCursor) after(other changeCursor) bool {
+	return c.block > other.block || (c.block == other.block && c.count > other.count)
+}
+
+func parseChangeCursor(value string) (changeCursor, error) {
+	block, count, ok := strings.Cut(value, ".")
+	if !ok {
+		return changeCursor{}, errors.New("invalid cursor")
+	}
+	b, err := strconv.ParseUint(block, 10, 64)
+	if err != nil {
+		return changeCursor{}, err
+	}
+	n, err := strconv.Atoi(count)
+	if err != nil || n < 0 {
+		return changeCursor{}, errors.New("invalid cursor")
+	}
+	return changeCursor{block: b, count: n}, nil
+}
+
+// changeFeed keeps the recent incident changes of each target in memory. Polls with an older cursor
+// than it holds are answered by replaying events from the peer.
+type changeFeed struct {
+	retention  time.Duration
+	maxChanges int
+
+	mu   sync.Mutex
+	logs map[string]*changeLog
+}
+
+type changeLog struct {
+	// floor is the position after which every change is held
+	floor changeCursor
+	// head is the position of the last event seen
+	head    changeCursor
+	changes []IncidentChange
+	// notify is closed and replaced when a change arrives
+	notify chan struct{}
+}
+
+var changes = newChangeFeed(time.Hour, 10000)
+
+// initChanges sets how long incident changes are kept for polling clients
+func initChanges() {
+	changes = newChangeFeed(getEnvDuration("CHANGES_RETENTION", time.Hour), getEnvInt("CHANGES_MAX_PER_TARGET", 10000))
+}
+
+func newChangeFeed(retention time.Duration, maxChanges int) *changeFeed {
+	return &changeFeed{retention: retention, maxChanges: maxChanges, logs: map[string]*changeLog{}}
+}
+
+// run follows the target's events from the current block, resuming from the last event seen
+// after a disconnection
+func (f *changeFeed) run(ctx context.Context, target *fabricTarget) {
+	ctx = withTarget(ctx, target)
+	backoff := time.Second
+	for ctx.Err() == nil {
+		err := f.follow(ctx, target)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("Incident change feed on %s stopped, retrying in %s: %v", target.Name, backoff, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, time.Minute)
+	}
+}
+
+func (f *changeFeed) follow(ctx context.Context, target *fabricTarget) error {
+	f.mu.Lock()
+	l := f.logs[target.Name]
+	f.mu.Unlock()
+	if l == nil {
+		height, err := channelHeight(ctx, target)
+		if err != nil {
+			return err
+		}
+		start := changeCursor{block: height}
+		l = &changeLog{floor: start, head: start, notify: make(chan struct{})}
+		f.mu.Lock()
+		f.logs[target.Name] = l
+		f.mu.Unlock()
+	}
+
+	f.mu.Lock()
+	from := l.head
+	f.mu.Unlock()
+	return streamChanges(ctx, target, from, func(pos changeCursor, change *IncidentChange) bool {
+		f.record(target.Name, pos, change, time.Now())
+		return true
+	})
+}
+
+// record advances the target's head and keeps change, if the event was one
+func (f *changeFeed) record(targetName string, pos changeCursor, change *IncidentChange, now time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	l := f.logs[targetName]
+	if l == nil {
+		l = &changeLog{notify: make(chan struct{})}
+		f.logs[targetName] = l
+	}
+	l.head = pos
+	if change == nil {
+		return
+	}
+	change.received = now
+	l.changes = append(l.changes, *change)
+	for len(l.changes) > 0 && (len(l.changes) > f.maxChanges || now.Sub(l.changes[0].received) > f.retention) {
+		l.floor = l.changes[0].position
+		l.changes = l.changes[1:]
+	}
+	close(l.notify)
+	l.notify = make(chan struct{})
+}
+
+var errChangesUnavailable = errors.New("the incident change feed has not started yet")
+
+// Wait returns the incident's changes after since, waiting up to wait for one to arrive. Without
+// since, only changes from now on are returned.
+func (f *changeFeed) Wait(ctx context.Context, target *fabricTarget, incidentID, since string, wait time.Duration) (*IncidentChanges, error) {
+	result := &IncidentChanges{IncidentID: incidentID, Changes: []IncidentChange{}}
+	var cursor *changeCursor
+	if since != "" {
+		parsed, err := parseChangeCursor(since)
+		if err != nil {
+			return nil, err
+		}
+		cursor = &parsed
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		f.mu.Lock()
+		l := f.logs[target.Name]
+		if l == nil || l.notify == nil {
+			f.mu.Unlock()
+			if cursor == nil {
+				return nil, errChangesUnavailable
+			}
+			return f.replay(ctx, target, incidentID, *cursor, wait)
+		}
+		if cursor == nil {
+			head := l.head
+			cursor = &head
+		}
+		if l.floor.after(*cursor) {
+			f.mu.Unlock()
+			return f.replay(ctx, target, incidentID, *cursor, wait)
+		}
+		for _, change := range l.changes {
+			if change.IncidentID == incidentID && change.position.after(*cursor) {
+				result.Changes = append(result.Changes, change)
+			}
+		}
+		result.Cursor = l.head.String()
+		notify := l.notify
+		f.mu.Unlock()
+
+		if len(result.Changes) > 0 {
+			return result, nil
+		}
+		select {
+		case <-notify:
+		case <-timer.C:
+			return result, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// replay reads the target's events after since from the peer, returning at the first change to the
+// incident or, when wait runs out, with the cursor advanced past the events read
+func (f *changeFeed) replay(ctx context.Context, target *fabricTarget, incidentID string, since changeCursor, wait time.Duration) (*IncidentChanges, error) {
+	result := &IncidentChanges{IncidentID: incidentID, Changes: []IncidentChange{}, Cursor: since.String()}
+	replayCtx, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
+	err := streamChanges(replayCtx, target, since, func(pos changeCursor, change *IncidentChange) bool {
+		result.Cursor = pos.String()
+		if change != nil && change.IncidentID == incidentID {
+			result.Changes = append(result.Changes, *change)
+			return false
+		}
+		return true
+	})
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil && replayCtx.Err() == nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// streamChanges passes each chaincode event after since to fn, with its incident change if it is
+// one, until fn returns false or the stream ends
+func streamChanges(ctx context.Context, target *fabricTarget, since changeCursor, fn func(changeCursor, *IncidentChange) bool) error {
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	events, err := target.network.ChaincodeEvents(streamCtx, target.Chaincode, client.WithStartBlock(since.block))
+	if err != nil {
+		return err
+	}
+	var pos changeCursor
+	for event := range events {
+		if event.BlockNumber != pos.block {
+			pos = changeCursor{block: event.BlockNumber}
+		}
+		pos.count++
+		if !pos.after(since) {
+			continue
+		}
+		if !fn(pos, incidentChangeFromEvent(event, pos)) {
+			return nil
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return errors.New("event stream closed")
+}
+
+// incidentChangeFromEvent describes an event as an incident change, or returns nil for events that
+// are not one
+func incidentChangeFromEvent(event *client.ChaincodeEvent, pos changeCursor) *IncidentChange {
+	var payload struct {
+		IncidentID   string `json:"incident_id"`
+		Status       string `json:"status"`
+		EvidenceID   string `json:"evidence_id"`
+		MediaType    string `json:"media_type"`
+		FIRID        string `json:"fir_id"`
+		DocumentHash string `json:"document_hash"`
+	}
+	if err := json.Unmarshal(event.Payload, &payload); err != nil || payload.IncidentID == "" {
+		return nil
+	}
+	change := &IncidentChange{
+		IncidentID:  payload.IncidentID,
+		TxID:        event.TransactionID,
+		BlockNumber: event.BlockNumber,
+		Cursor:      pos.String(),
+		position:    pos,
+	}
+	switch event.EventName {
+	case "UpdateIncidentStatus":
+		change.Type, change.Status = changeStatus, payload.Status
+	case "CreateEvidence":
+		change.Type, change.EvidenceID, change.MediaType = changeEvidence, payload.EvidenceID, payload.MediaType
+	case "RecordEFIR":
+		change.Type, change.FIRID, change.DocumentHash = changeEFIR, payload.FIRID, payload.DocumentHash
+	case "DeleteIncident":
+		change.Type = changeDeleted
+	default:
+		return nil
+	}
+	return change
+}
+
+func getIncidentChanges(c *gin.Context) {
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	var req IncidentChangesRequest
+	if !bindQuery(c, &req) {
+		return
+	}
+
+	ctx := c.Request.Context()
+	if _, err := ledger.GetIncident(ctx, id); err != nil {
+		respondServiceError(c, "Failed to read incident", err)
+		return
+	}
+	wait := maxChangesWait
+	if req.Wait > 0 {
+		wait = time.Duration(req.Wait) * time.Second
+	}
+	result, err := changes.Wait(ctx, targetFromContext(ctx), id, req.Since, wait)
+	if errors.Is(err, errChangesUnavailable) {
+		c.Header("Retry-After", "5")
+		respondError(c, http.StatusServiceUnavailable, errCodeChangesUnavailable, "Incident changes are not available yet")
+		return
+	}
+	if err != nil {
+		respondServiceError(c, "Failed to read incident changes", err)
+		return
+	}
+	respondData(c, http.StatusOK, result)
+}
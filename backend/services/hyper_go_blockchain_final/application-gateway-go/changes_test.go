@@ -0,0 +1,70 @@
+package gateway
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+func TestIncidentChanges(t *testing.T) {
+	for _, value := range []string{"", "12", "a.1", "12.-1", "12.x"} {
+		if _, err := parseChangeCursor(value); err == nil {
+			t.Errorf("expected %q rejected", value)
+		}
+	}
+	if cursor, err := parseChangeCursor("12.3"); err != nil || cursor != (changeCursor{block: 12, count: 3}) || cursor.String() != "12.3" {
+		t.Errorf("unexpected cursor %v %v", cursor, err)
+	}
+	if errs := (IncidentChangesRequest{Since: "bad", Wait: 31}).Validate(); len(errs) != 2 {
+		t.Errorf("expected since and wait rejected, got %v", errs)
+	}
+
+	pos := changeCursor{block: 7, count: 2}
+	change := incidentChangeFromEvent(&client.ChaincodeEvent{
+		EventName: "RecordEFIR", BlockNumber: 7, TransactionID: "tx-1",
+		Payload: []byte(`{"fir_id":"fir-1","incident_id":"inc-1","document_hash":"abc"}`),
+	}, pos)
+	if change == nil || change.Type != changeEFIR || change.FIRID != "fir-1" || change.Cursor != "7.2" || change.TxID != "tx-1" {
+		t.Errorf("unexpected e-FIR change %+v", change)
+	}
+	if change := incidentChangeFromEvent(&client.ChaincodeEvent{EventName: "UpdateIncident", Payload: []byte(`{"incident_id":"inc-1"}`)}, pos); change != nil {
+		t.Errorf("expected edits other than status ignored, got %+v", change)
+	}
+
+	feed := newChangeFeed(time.Hour, 2)
+	target := &fabricTarget{Name: "default"}
+	if _, err := feed.Wait(context.Background(), target, "inc-1", "", time.Millisecond); err != errChangesUnavailable {
+		t.Fatalf("expected the feed unavailable before it starts, got %v", err)
+	}
+	start := changeCursor{block: 5}
+	feed.logs["default"] = &changeLog{floor: start, head: start, notify: make(chan struct{})}
+
+	status := func(pos changeCursor, incidentID string) *IncidentChange {
+		return &IncidentChange{Type: changeStatus, IncidentID: incidentID, Status: "resolved", Cursor: pos.String(), position: pos}
+	}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		feed.record("default", changeCursor{block: 6, count: 1}, nil, time.Now())
+		feed.record("default", changeCursor{block: 6, count: 2}, status(changeCursor{block: 6, count: 2}, "inc-1"), time.Now())
+	}()
+	result, err := feed.Wait(context.Background(), target, "inc-1", "", time.Second)
+	if err != nil || len(result.Changes) != 1 || result.Cursor != "6.2" {
+		t.Fatalf("expected the poll woken by the status change, got %+v %v", result, err)
+	}
+
+	feed.record("default", changeCursor{block: 7, count: 1}, status(changeCursor{block: 7, count: 1}, "inc-2"), time.Now())
+	result, err = feed.Wait(context.Background(), target, "inc-1", result.Cursor, 10*time.Millisecond)
+	if err != nil || len(result.Changes) != 0 || result.Cursor != "7.1" {
+		t.Errorf("expected other incidents skipped and the cursor advanced, got %+v %v", result, err)
+	}
+	feed.record("default", changeCursor{block: 8, count: 1}, status(changeCursor{block: 8, count: 1}, "inc-1"), time.Now())
+	if l := feed.logs["default"]; len(l.changes) != 2 || l.floor != (changeCursor{block: 6, count: 2}) {
+		t.Errorf("expected the oldest change pruned beyond the limit, got floor %v and %d changes", l.floor, len(l.changes))
+	}
+	result, err = feed.Wait(context.Background(), target, "inc-1", "6.2", time.Millisecond)
+	if err != nil || len(result.Changes) != 1 || result.Changes[0].Cursor != "8.1" {
+		t.Errorf("expected changes after a held cursor returned at once, got %+v %v", result, err)
+	}
+}
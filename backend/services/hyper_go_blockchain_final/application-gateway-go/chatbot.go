@@ -0,0 +1,443 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	errCodeChatbotDisabled = "CHATBOT_DISABLED"
+
+	// chatbotReporter is the reporter of incidents tourists report in chat
+	chatbotReporter   = "chatbot"
+	maxChatbotBody    = 256 << 10
+	rasaActionPrefix  = "action_"
+	chatbotDateLayout = "2006-01-02"
+)
+
+// Chat intents. Dialogflow intents are matched by display name and Rasa custom actions by name,
+// with or without the action_ prefix.
+const (
+	intentVerifyDID   = "verify_did"
+	intentReportIssue = "report_issue"
+	intentNearestHelp = "nearest_help"
+	intentSOS         = "trigger_sos"
+)
+
+var chatIntents = []string{intentVerifyDID, intentReportIssue, intentNearestHelp, intentSOS}
+
+// chatIssueCategories are the incident categories a tourist may report in chat; anything else is
+// recorded as other
+var chatIssueCategories = []string{"theft", "harassment", "medical", "accident", "other"}
+
+// chatbotService answers fulfillment webhooks from Dialogflow ES and custom action calls from Rasa.
+// Both platforms are trusted to have identified the tourist: the digital ID comes from the
+// conversation, so the webhook token must stay with the bot.
+type chatbotService struct {
+	token []byte
+}
+
+var chatbot *chatbotService
+
+func initChatbot() {
+	token := getEnv("CHATBOT_WEBHOOK_TOKEN", "")
+	if token == "" {
+		log.Println("💬 CHATBOT_WEBHOOK_TOKEN not set, chat assistant webhooks disabled")
+		return
+	}
+	chatbot = &chatbotService{token: []byte(token)}
+	log.Println("💬 Chat assistant webhooks enabled for Dialogflow and Rasa")
+}
+
+// chatTurn is one tourist message as either platform describes it. Params merges the platform's
+// parameters, slots and client payload.
+type chatTurn struct {
+	platform  string
+	session   string
+	turnID    string
+	intent    string
+	languages []string
+	params    map[string]any
+}
+
+func (t chatTurn) param(key string) string {
+	switch value := t.params[key].(type) {
+	case string:
+		return strings.TrimSpace(value)
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	}
+	return ""
+}
+
+func (t chatTurn) coordinate(key string) (float64, bool) {
+	value, err := strconv.ParseFloat(t.param(key), 64)
+	return value, err == nil
+}
+
+// chatReply is what the assistant says back. Reference is the ID of the incident or SOS alert the
+// turn recorded, if any.
+type chatReply struct {
+	text      string
+	reference string
+}
+
+// say translates a reply into the first of the turn's languages that has it
+func (t chatTurn) say(format string, args ...interface{}) string {
+	text, _ := messages.translate(t.languages, format, args...)
+	return text
+}
+
+// reference derives the ID of what a turn records from the turn, so a redelivered webhook finds
+// the record it already made. Turns without an ID get a random one.
+func (t chatTurn) reference() string {
+	turn := t.turnID
+	if turn == "" {
+		nonce := make([]byte, 8)
+		rand.Read(nonce)
+		turn = hex.EncodeToString(nonce)
+	}
+	sum := sha256.Sum256([]byte(t.platform + "|" + t.session + "|" + turn))
+	return "CHAT-" + time.Now().UTC().Format("20060102") + "-" + hex.EncodeToString(sum[:6])
+}
+
+// answer carries out the turn's intent through the same services as the API
+func (s *chatbotService) answer(ctx context.Context, t chatTurn) chatReply {
+	var reply chatReply
+	var err error
+	switch t.intent {
+	case intentVerifyDID:
+		reply, err = s.verifyDID(ctx, t)
+	case intentReportIssue:
+		reply, err = s.reportIssue(ctx, t)
+	case intentNearestHelp:
+		reply = s.nearestHelp(ctx, t)
+	case intentSOS:
+		reply, err = s.raiseSOS(ctx, t)
+	default:
+		return chatReply{text: t.say("Sorry, I cannot help with that yet.")}
+	}
+	if err != nil {
+		log.Printf("💬 Failed to answer %s intent %s in session %s: %v", t.platform, t.intent, t.session, err)
+		return chatReply{text: t.say("Sorry, something went wrong. In an emergency, call 112.")}
+	}
+	return reply
+}
+
+func (s *chatbotService) verifyDID(ctx context.Context, t chatTurn) (chatReply, error) {
+	id := t.param("digital_id")
+	if id == "" {
+		return chatReply{text: t.say("Please tell me the digital ID, such as did:sih:tourist123.")}, nil
+	}
+	verdict, err := ledger.VerifyDID(ctx, id)
+	var validationErrs ValidationErrors
+	if errors.As(err, &validationErrs) {
+		return chatReply{text: t.say("Please tell me the digital ID, such as did:sih:tourist123.")}, nil
+	}
+	if err != nil {
+		return chatReply{}, err
+	}
+	switch {
+	case verdict.Valid:
+		return chatReply{text: t.say("%s is a valid digital ID until %s.", id, chatDate(verdict.ExpiresAt))}, nil
+	case verdict.Expired:
+		return chatReply{text: t.say("%s expired on %s.", id, chatDate(verdict.ExpiresAt))}, nil
+	case verdict.Revoked:
+		return chatReply{text: t.say("%s has been revoked.", id)}, nil
+	case !verdict.Exists:
+		return chatReply{text: t.say("%s is not a registered digital ID.", id)}, nil
+	}
+	return chatReply{text: t.say("%s could not be verified. Please contact the issuing office.", id)}, nil
+}
+
+func chatDate(timestamp string) string {
+	if at, err := time.Parse(time.RFC3339, timestamp); err == nil {
+		return at.UTC().Format(chatbotDateLayout)
+	}
+	return timestamp
+}
+
+// reportIssue records a low severity incident. Like other reporters, the assistant puts only a hash
+// of the description on the ledger; the conversation stays with the bot platform.
+func (s *chatbotService) reportIssue(ctx context.Context, t chatTurn) (chatReply, error) {
+	digitalID, description := t.param("digital_id"), t.param("description")
+	if digitalID == "" {
+		return chatReply{text: t.say("Please tell me the digital ID, such as did:sih:tourist123.")}, nil
+	}
+	if description == "" {
+		return chatReply{text: t.say("Please describe the issue.")}, nil
+	}
+	category := t.param("category")
+	if !slices.Contains(chatIssueCategories, category) {
+		category = "other"
+	}
+	sum := sha256.Sum256([]byte(description))
+	req := CreateIncidentRequest{
+		IncidentID:          t.reference(),
+		IncidentSummaryHash: hex.EncodeToString(sum[:]),
+		Reporter:            chatbotReporter,
+		Severity:            "low",
+		Category:            category,
+		DigitalID:           digitalID,
+	}
+	if lat, lng, ok := s.position(ctx, t, digitalID); ok {
+		req.Latitude, req.Longitude = &lat, &lng
+	}
+	_, err := ledger.CreateIncident(ctx, req)
+	var validationErrs ValidationErrors
+	switch {
+	case errors.As(err, &validationErrs):
+		return chatReply{text: t.say("Please tell me the digital ID, such as did:sih:tourist123.")}, nil
+	case err != nil && translateFabricError(err).Code != errCodeAlreadyExists:
+		return chatReply{}, err
+	}
+	return chatReply{text: t.say("Your report has been recorded with reference %s.", req.IncidentID), reference: req.IncidentID}, nil
+}
+
+// nearestHelp finds the closest police or medical unit in the unit directory, whether or not it is
+// on shift, since tourists are told where to go rather than who is coming
+func (s *chatbotService) nearestHelp(ctx context.Context, t chatTurn) chatReply {
+	lat, lng, ok := s.position(ctx, t, t.param("digital_id"))
+	if !ok {
+		return chatReply{text: t.say("Please share your location and try again.")}
+	}
+	kind := t.param("kind")
+	if kind != unitMedical {
+		kind = unitPolice
+	}
+	unit, distance := nearestUnit(policeUnits, lat, lng, float64(getEnvInt("SOS_MAX_UNIT_DISTANCE_KM", 50)), func(u *PoliceUnit) bool {
+		return u.kind() == kind
+	})
+	if unit == nil {
+		return chatReply{text: t.say("No help centre is registered near you. Call 112.")}
+	}
+	text := t.say("The nearest police station is %s, %.1f km away.", unit.Name, distance)
+	if kind == unitMedical {
+		text = t.say("The nearest medical centre is %s, %.1f km away.", unit.Name, distance)
+	}
+	if unit.Phone != "" {
+		text += " " + t.say("Call %s.", unit.Phone)
+	}
+	return chatReply{text: text}
+}
+
+// raiseSOS raises an SOS alert with source chat, routed and notified like one from the app
+func (s *chatbotService) raiseSOS(ctx context.Context, t chatTurn) (chatReply, error) {
+	digitalID := t.param("digital_id")
+	if digitalID == "" {
+		return chatReply{text: t.say("Please tell me the digital ID, such as did:sih:tourist123.")}, nil
+	}
+	lat, lng, ok := s.position(ctx, t, digitalID)
+	if !ok {
+		return chatReply{text: t.say("Please share your location and try again.")}, nil
+	}
+	message := t.param("description")
+	if len(message) > maxSOSMessageLength {
+		// Dropping the rune the cut split keeps the message valid UTF-8
+		message = strings.ToValidUTF8(message[:maxSOSMessageLength], "")
+	}
+	alertID := t.reference()
+	result, err := ledger.RaiseSOS(ctx, SOSRequest{AlertID: alertID, DigitalID: digitalID, Latitude: &lat, Longitude: &lng, Message: message, Source: "chat"})
+	var validationErrs ValidationErrors
+	switch {
+	case errors.As(err, &validationErrs):
+		return chatReply{text: t.say("Please tell me the digital ID, such as did:sih:tourist123.")}, nil
+	case err != nil && translateFabricError(err).Code == errCodeAlreadyExists:
+		result = &SOSResult{AlertID: alertID}
+	case err != nil:
+		return chatReply{}, err
+	}
+	if unit := result.PoliceUnit; unit != nil {
+		return chatReply{text: t.say("SOS raised with reference %s. %s is %.1f km away and has been alerted.", alertID, unit.Name, unit.DistanceKm), reference: alertID}, nil
+	}
+	return chatReply{text: t.say("SOS raised with reference %s. Help is being arranged; call 112 if you can.", alertID), reference: alertID}, nil
+}
+
+// position is the latitude and longitude the bot passed, or else the tourist's live location
+func (s *chatbotService) position(ctx context.Context, t chatTurn, digitalID string) (float64, float64, bool) {
+	lat, latOK := t.coordinate("latitude")
+	lng, lngOK := t.coordinate("longitude")
+	if latOK && lngOK && lat >= -90 && lat <= 90 && lng >= -180 && lng <= 180 {
+		return lat, lng, true
+	}
+	if digitalID == "" || locations == nil {
+		return 0, 0, false
+	}
+	live, err := locations.store.latest(ctx, digitalID)
+	if err != nil || live == nil {
+		return 0, 0, false
+	}
+	return live.Latitude, live.Longitude, true
+}
+
+// authorized accepts the webhook token as a bearer token, which Dialogflow sends as a configured
+// header, or as the token query parameter Rasa adds to its action endpoint
+func (s *chatbotService) authorized(c *gin.Context) bool {
+	token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok {
+		token = c.Query("token")
+	}
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), s.token) == 1
+}
+
+// readChatbotRequest authenticates a webhook and decodes its body, answering the error itself
+func readChatbotRequest(c *gin.Context, body any) bool {
+	if chatbot == nil {
+		respondError(c, http.StatusServiceUnavailable, errCodeChatbotDisabled, "The chat assistant is not configured")
+		return false
+	}
+	if !chatbot.authorized(c) {
+		respondError(c, http.StatusUnauthorized, errCodeUnauthenticated, "Webhook token verification failed")
+		return false
+	}
+	data, err := io.ReadAll(io.LimitReader(c.Request.Body, maxChatbotBody+1))
+	if err != nil || len(data) > maxChatbotBody {
+		respondError(c, http.StatusBadRequest, errCodeValidation, "The webhook body could not be read")
+		return false
+	}
+	if err := json.Unmarshal(data, body); err != nil {
+		respondError(c, http.StatusBadRequest, errCodeValidation, "The webhook body is not a chat request")
+		return false
+	}
+	return true
+}
+
+// mergeParams copies the non-empty values of each source over the ones before it
+func mergeParams(sources ...map[string]any) map[string]any {
+	params := map[string]any{}
+	for _, source := range sources {
+		for key, value := range source {
+			if value != nil && value != "" {
+				params[key] = value
+			}
+		}
+	}
+	return params
+}
+
+// dialogflowRequest is the part of a Dialogflow ES WebhookRequest the assistant reads. The tourist
+// app may send digital_id, latitude and longitude in the detect intent payload.
+type dialogflowRequest struct {
+	ResponseID  string `json:"responseId"`
+	Session     string `json:"session"`
+	QueryResult struct {
+		Parameters   map[string]any `json:"parameters"`
+		LanguageCode string         `json:"languageCode"`
+		Intent       struct {
+			DisplayName string `json:"displayName"`
+		} `json:"intent"`
+	} `json:"queryResult"`
+	OriginalDetectIntentRequest struct {
+		Payload map[string]any `json:"payload"`
+	} `json:"originalDetectIntentRequest"`
+}
+
+type dialogflowResponse struct {
+	FulfillmentText     string              `json:"fulfillmentText"`
+	FulfillmentMessages []dialogflowMessage `json:"fulfillmentMessages"`
+	Payload             map[string]string   `json:"payload,omitempty"`
+}
+
+type dialogflowMessage struct {
+	Text struct {
+		Text []string `json:"text"`
+	} `json:"text"`
+}
+
+// dialogflowWebhook fulfills Dialogflow ES intents. Unknown intents are answered rather than
+// rejected, since Dialogflow shows the webhook's reply either way.
+func dialogflowWebhook(c *gin.Context) {
+	var req dialogflowRequest
+	if !readChatbotRequest(c, &req) {
+		return
+	}
+	turn := chatTurn{
+		platform:  "dialogflow",
+		session:   req.Session,
+		turnID:    req.ResponseID,
+		intent:    req.QueryResult.Intent.DisplayName,
+		languages: messages.negotiate(req.QueryResult.LanguageCode),
+		params:    mergeParams(req.OriginalDetectIntentRequest.Payload, req.QueryResult.Parameters),
+	}
+	reply := chatbot.answer(c.Request.Context(), turn)
+	resp := dialogflowResponse{FulfillmentText: reply.text, FulfillmentMessages: []dialogflowMessage{{}}}
+	resp.FulfillmentMessages[0].Text.Text = []string{reply.text}
+	if reply.reference != "" {
+		resp.Payload = map[string]string{"reference": reply.reference}
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// rasaRequest is the part of a Rasa action server call the assistant reads. Slots take precedence
+// over the latest message's metadata, where channels put the client's digital ID and position.
+type rasaRequest struct {
+	NextAction string `json:"next_action"`
+	SenderID   string `json:"sender_id"`
+	Tracker    struct {
+		Slots         map[string]any `json:"slots"`
+		LatestMessage struct {
+			MessageID string         `json:"message_id"`
+			Metadata  map[string]any `json:"metadata"`
+		} `json:"latest_message"`
+	} `json:"tracker"`
+}
+
+type rasaResponse struct {
+	Events    []map[string]any    `json:"events"`
+	Responses []map[string]string `json:"responses"`
+}
+
+// rasaWebhook runs Rasa custom actions. A reply that recorded something sets the sih_reference slot.
+func rasaWebhook(c *gin.Context) {
+	var req rasaRequest
+	if !readChatbotRequest(c, &req) {
+		return
+	}
+	intent := strings.TrimPrefix(req.NextAction, rasaActionPrefix)
+	if !slices.Contains(chatIntents, intent) {
+		// Rasa reads a 404 with the action name as an action this server does not run
+		c.JSON(http.StatusNotFound, gin.H{"error": "No registered action found for name '" + req.NextAction + "'.", "action_name": req.NextAction})
+		return
+	}
+	params := mergeParams(req.Tracker.LatestMessage.Metadata, req.Tracker.Slots)
+	// Channels that key conversations by the tourist's DID need not set a slot
+	var v fieldValidator
+	if v.digitalID("sender_id", req.SenderID); params["digital_id"] == nil && len(v.errors) == 0 {
+		params["digital_id"] = req.SenderID
+	}
+	language, _ := params["language"].(string)
+	turn := chatTurn{
+		platform:  "rasa",
+		session:   req.SenderID,
+		turnID:    req.Tracker.LatestMessage.MessageID,
+		intent:    intent,
+		languages: messages.negotiate(language),
+		params:    params,
+	}
+	reply := chatbot.answer(c.Request.Context(), turn)
+	resp := rasaResponse{Events: []map[string]any{}, Responses: []map[string]string{{"text": reply.text}}}
+	if reply.reference != "" {
+		resp.Events = append(resp.Events, map[string]any{"event": "slot", "name": "sih_reference", "value": reply.reference})
+	}
+	c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,100 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestChatbotWebhooks(t *testing.T) {
+	chatbot = &chatbotService{token: []byte("bot-token")}
+	previousUnits, previousLocations, previousMessages := policeUnits, locations, messages
+	defer func() {
+		chatbot, policeUnits, locations, messages = nil, previousUnits, previousLocations, previousMessages
+	}()
+	policeUnits = []PoliceUnit{
+		{ID: "sadar", Name: "Shillong Sadar PS", Latitude: 25.5788, Longitude: 91.8933, Phone: "+913642222222"},
+		{ID: "civil", Name: "Civil Hospital", Kind: unitMedical, Latitude: 25.5700, Longitude: 91.8800},
+	}
+	locations = &locationService{store: newMemoryLocationStore(time.Hour)}
+	messages = &localizer{catalogs: map[string]*messageCatalog{}, defaultLanguage: sourceLanguage}
+	if err := messages.load(builtinLocales, "locales"); err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/callbacks/chatbot/dialogflow", dialogflowWebhook)
+	r.POST("/callbacks/chatbot/rasa", rasaWebhook)
+	post := func(path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := post("/callbacks/chatbot/dialogflow", "forged", `{}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected a forged token rejected, got %d", w.Code)
+	}
+
+	// Dialogflow: the position comes from the app's payload, the reply in the session's language
+	w := post("/callbacks/chatbot/dialogflow", "bot-token", `{
+		"responseId": "r-1", "session": "projects/sih/agent/sessions/s-1",
+		"queryResult": {"intent": {"displayName": "nearest_help"}, "languageCode": "hi", "parameters": {"kind": "police"}},
+		"originalDetectIntentRequest": {"payload": {"latitude": 25.5800, "longitude": 91.8900}}
+	}`)
+	var fulfillment dialogflowResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &fulfillment); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("unexpected response %d %s", w.Code, w.Body)
+	}
+	if want := "निकटतम पुलिस स्टेशन Shillong Sadar PS है, 0.4 किमी दूर। +913642222222 पर कॉल करें।"; fulfillment.FulfillmentText != want || fulfillment.FulfillmentMessages[0].Text.Text[0] != want {
+		t.Errorf("unexpected fulfillment %+v", fulfillment)
+	}
+
+	// Rasa: the token is a query parameter, the sender is the tourist, and the live location is used
+	locations.store.update(context.Background(), LiveLocation{DigitalID: "did:sih:t1", Latitude: 25.5701, Longitude: 91.8801, ReceivedAt: time.Now().UTC().Format(time.RFC3339)})
+	w = post("/callbacks/chatbot/rasa?token=bot-token", "", `{
+		"next_action": "action_nearest_help", "sender_id": "did:sih:t1",
+		"tracker": {"slots": {"kind": "medical", "language": null}, "latest_message": {"message_id": "m-1", "metadata": {"language": "en"}}}
+	}`)
+	var action rasaResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &action); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("unexpected response %d %s", w.Code, w.Body)
+	}
+	if len(action.Responses) != 1 || action.Responses[0]["text"] != "The nearest medical centre is Civil Hospital, 0.0 km away." {
+		t.Errorf("unexpected action response %+v", action)
+	}
+
+	// Answers that need more from the tourist do not touch the ledger
+	w = post("/callbacks/chatbot/rasa?token=bot-token", "", `{"next_action": "action_trigger_sos", "sender_id": "whatsapp:+9199", "tracker": {"slots": {}, "latest_message": {"metadata": {"language": "bn-IN"}}}}`)
+	if err := json.Unmarshal(w.Body.Bytes(), &action); err != nil || action.Responses[0]["text"] != "অনুগ্রহ করে ডিজিটাল আইডিটি বলুন, যেমন did:sih:tourist123।" {
+		t.Errorf("expected the digital ID asked for in Bengali, got %s", w.Body)
+	}
+	if w = post("/callbacks/chatbot/rasa?token=bot-token", "", `{"next_action": "action_book_taxi", "sender_id": "did:sih:t1"}`); w.Code != http.StatusNotFound {
+		t.Errorf("expected an unknown Rasa action reported missing, got %d", w.Code)
+	}
+}
+
+func TestChatTurnReference(t *testing.T) {
+	turn := chatTurn{platform: "dialogflow", session: "s-1", turnID: "r-1"}
+	if first := turn.reference(); first != turn.reference() || len(validateDocumentID("id", first)) > 0 {
+		t.Errorf("expected a stable, valid reference per turn, got %s", first)
+	}
+	other := chatTurn{platform: "dialogflow", session: "s-1", turnID: "r-2"}
+	if turn.reference() == other.reference() {
+		t.Error("expected references distinct per turn")
+	}
+	if (chatTurn{params: map[string]any{"latitude": "25.5"}}).param("latitude") != "25.5" ||
+		(chatTurn{params: map[string]any{"latitude": 25.5}}).param("latitude") != "25.5" {
+		t.Error("expected string and numeric parameters read alike")
+	}
+}
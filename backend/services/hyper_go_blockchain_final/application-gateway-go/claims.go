@@ -0,0 +1,436 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	errCodeClaimsDisabled = "CLAIMS_DISABLED"
+
+	maxClaimEvidence    = 100
+	maxClaimNumberChars = 64
+)
+
+// Claim evidence statuses reported by verification
+const (
+	claimEvidenceIntact  = "intact"
+	claimEvidenceChanged = "changed"
+	claimEvidenceMissing = "missing"
+)
+
+// Insurer is an insurance company allowed to reference incidents in claims
+type Insurer struct {
+	InsurerID string `json:"insurer_id"`
+	Name      string `json:"name"`
+}
+
+// RegisterClaimRequest links an insurer's claim to an incident. EvidenceIDs lists the evidence the
+// claim cites; leaving it empty cites all of the incident's evidence. The claim number is hashed
+// before it reaches the ledger.
+type RegisterClaimRequest struct {
+	IncidentID  string   `json:"incidentID" binding:"required"`
+	InsurerID   string   `json:"insurerID" binding:"required"`
+	ClaimNumber string   `json:"claimNumber" binding:"required"`
+	EvidenceIDs []string `json:"evidenceIDs"`
+	Actor       string   `json:"actor" binding:"required"`
+}
+
+func (r RegisterClaimRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.identifier("incidentID", r.IncidentID)
+	v.identifier("insurerID", r.InsurerID)
+	validateClaimNumber(&v, "claimNumber", r.ClaimNumber)
+	if len(r.EvidenceIDs) > maxClaimEvidence {
+		v.add("evidenceIDs", "must list at most %d evidence items", maxClaimEvidence)
+	}
+	for i, id := range r.EvidenceIDs {
+		v.identifier(fmt.Sprintf("evidenceIDs[%d]", i), id)
+		if slices.Index(r.EvidenceIDs, id) != i {
+			v.add(fmt.Sprintf("evidenceIDs[%d]", i), "is listed twice")
+		}
+	}
+	v.identifier("actor", r.Actor)
+	return v.errors
+}
+
+// validateClaimNumber accepts insurers' own formats, such as "CLM/2025/00042", as long as they are
+// printable and short
+func validateClaimNumber(v *fieldValidator, field, value string) {
+	trimmed := strings.TrimSpace(value)
+	if trimmed == "" || len(trimmed) > maxClaimNumberChars || strings.IndexFunc(trimmed, func(r rune) bool { return !unicode.IsPrint(r) }) >= 0 {
+		v.add(field, "must be 1-%d printable characters", maxClaimNumberChars)
+	}
+}
+
+// ClaimVerificationRequest optionally lets the insurer confirm the reference is for its claim
+type ClaimVerificationRequest struct {
+	ClaimNumber string `form:"claimNumber"`
+}
+
+func (r ClaimVerificationRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	if r.ClaimNumber != "" {
+		validateClaimNumber(&v, "claimNumber", r.ClaimNumber)
+	}
+	return v.errors
+}
+
+// ClaimEvidence is one evidence item a claim cites, with its hash when the claim was registered
+type ClaimEvidence struct {
+	EvidenceID   string `json:"evidence_id"`
+	EvidenceHash string `json:"evidence_hash"`
+}
+
+// ClaimReference is a claim reference as recorded on the ledger
+type ClaimReference struct {
+	DocType             string          `json:"doc_type"`
+	ClaimRefID          string          `json:"claim_ref_id"`
+	IncidentID          string          `json:"incident_id"`
+	DigitalID           string          `json:"digital_id,omitempty"`
+	InsurerID           string          `json:"insurer_id"`
+	ClaimNumberHash     string          `json:"claim_number_hash"`
+	IncidentSummaryHash string          `json:"incident_summary_hash"`
+	Evidence            []ClaimEvidence `json:"evidence"`
+	EvidenceDigest      string          `json:"evidence_digest"`
+	RegisteredBy        string          `json:"registered_by"`
+	RegisteredAt        string          `json:"registered_at"`
+	OwnerOrg            string          `json:"owner_org,omitempty"`
+	TxID                string          `json:"tx_id"`
+}
+
+// ClaimEvidenceCheck reports whether a cited evidence item still has the hash it had when the
+// claim was registered
+type ClaimEvidenceCheck struct {
+	EvidenceID     string `json:"evidence_id"`
+	Status         string `json:"status"`
+	RegisteredHash string `json:"registered_hash"`
+	CurrentHash    string `json:"current_hash,omitempty"`
+	CurrentTxID    string `json:"current_tx_id,omitempty"`
+}
+
+// ClaimVerification tells an insurer whether the incident and evidence a claim rests on are still
+// as they were when the claim was registered. Valid requires the record's digest to match its
+// evidence list, the incident's summary to be unchanged and every cited item to be intact, and,
+// when a claim number was given, that number to match.
+type ClaimVerification struct {
+	ClaimRefID         string               `json:"claim_ref_id"`
+	IncidentID         string               `json:"incident_id"`
+	InsurerID          string               `json:"insurer_id"`
+	Valid              bool                 `json:"valid"`
+	DigestValid        bool                 `json:"digest_valid"`
+	IncidentFound      bool                 `json:"incident_found"`
+	IncidentUnchanged  bool                 `json:"incident_unchanged"`
+	IncidentStatus     string               `json:"incident_status,omitempty"`
+	ClaimNumberMatches *bool                `json:"claim_number_matches,omitempty"`
+	Evidence           []ClaimEvidenceCheck `json:"evidence"`
+	RegisteredAt       string               `json:"registered_at"`
+	TxID               string               `json:"tx_id"`
+	VerifiedAt         string               `json:"verified_at"`
+}
+
+type claimRegistry struct {
+	insurers map[string]Insurer
+}
+
+var claims *claimRegistry
+
+// initClaims loads the insurers allowed to reference incidents
+func initClaims() {
+	path := getEnv("INSURERS_FILE", "")
+	if path == "" {
+		log.Println("🧾 INSURERS_FILE not set, insurance claim references disabled")
+		return
+	}
+	insurers, err := readInsurers(path)
+	if err != nil {
+		panic(err)
+	}
+	claims = &claimRegistry{insurers: insurers}
+	log.Printf("🧾 Accepting claim references from %d insurers", len(insurers))
+}
+
+func readInsurers(path string) (map[string]Insurer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read insurers: %w", err)
+	}
+	var list []Insurer
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("failed to parse insurers: %w", err)
+	}
+	insurers := make(map[string]Insurer, len(list))
+	for _, insurer := range list {
+		if _, ok := insurers[insurer.InsurerID]; ok || len(validateDocumentID("insurer_id", insurer.InsurerID)) > 0 {
+			return nil, fmt.Errorf("insurer %q is not a unique identifier", insurer.InsurerID)
+		}
+		if insurer.Name == "" {
+			return nil, fmt.Errorf("insurer %s needs a name", insurer.InsurerID)
+		}
+		insurers[insurer.InsurerID] = insurer
+	}
+	return insurers, nil
+}
+
+// insurerName returns the insurer's registered name, or its ID when claims are disabled on this
+// instance or the insurer has since been removed
+func insurerName(insurerID string) string {
+	if claims != nil {
+		if insurer, ok := claims.insurers[insurerID]; ok {
+			return insurer.Name
+		}
+	}
+	return insurerID
+}
+
+func newClaimRefID(now time.Time) string {
+	suffix := make([]byte, 3)
+	rand.Read(suffix)
+	return fmt.Sprintf("CLAIM-%s-%s", now.UTC().Format("20060102T150405Z"), hex.EncodeToString(suffix))
+}
+
+// claimNumberHash binds a claim number to its insurer, so equal numbers at two insurers differ
+func claimNumberHash(insurerID, claimNumber string) string {
+	sum := sha256.Sum256([]byte(insurerID + ":" + strings.TrimSpace(claimNumber)))
+	return hex.EncodeToString(sum[:])
+}
+
+// claimEvidenceDigest matches the chaincode's: a SHA-256 over the evidence in ID order, one
+// "id:hash" line each
+func claimEvidenceDigest(evidence []ClaimEvidence) string {
+	sorted := slices.Clone(evidence)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].EvidenceID < sorted[j].EvidenceID })
+	h := sha256.New()
+	for _, item := range sorted {
+		fmt.Fprintf(h, "%s:%s\n", item.EvidenceID, item.EvidenceHash)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// checkClaim compares a claim reference with the incident and evidence now on the ledger. incident
+// is nil when the incident has been deleted.
+func checkClaim(claim ClaimReference, incident *IncidentDocument, current []EvidenceDocument, claimNumber string) ClaimVerification {
+	verdict := ClaimVerification{
+		ClaimRefID: claim.ClaimRefID, IncidentID: claim.IncidentID, InsurerID: claim.InsurerID,
+		DigestValid:  claimEvidenceDigest(claim.Evidence) == claim.EvidenceDigest,
+		RegisteredAt: claim.RegisteredAt, TxID: claim.TxID, Evidence: []ClaimEvidenceCheck{},
+	}
+	if incident != nil {
+		verdict.IncidentFound = true
+		verdict.IncidentUnchanged = incident.IncidentSummaryHash == claim.IncidentSummaryHash
+		verdict.IncidentStatus = incident.Status
+	}
+	byID := make(map[string]EvidenceDocument, len(current))
+	for _, e := range current {
+		byID[e.EvidenceID] = e
+	}
+	intact := true
+	for _, cited := range claim.Evidence {
+		check := ClaimEvidenceCheck{EvidenceID: cited.EvidenceID, RegisteredHash: cited.EvidenceHash, Status: claimEvidenceMissing}
+		if e, ok := byID[cited.EvidenceID]; ok {
+			check.CurrentHash, check.CurrentTxID = e.EvidenceHash, e.TxID
+			check.Status = claimEvidenceIntact
+			if e.EvidenceHash != cited.EvidenceHash {
+				check.Status = claimEvidenceChanged
+			}
+		}
+		intact = intact && check.Status == claimEvidenceIntact
+		verdict.Evidence = append(verdict.Evidence, check)
+	}
+	verdict.Valid = verdict.DigestValid && verdict.IncidentUnchanged && intact
+	if claimNumber != "" {
+		matches := claimNumberHash(claim.InsurerID, claimNumber) == claim.ClaimNumberHash
+		verdict.ClaimNumberMatches = &matches
+		verdict.Valid = verdict.Valid && matches
+	}
+	return verdict
+}
+
+// RegisterClaimReference anchors a claim reference for an incident the caller can read. An
+// organization holding an access grant on the incident, such as an insurer's, may register one.
+func (s ledgerService) RegisterClaimReference(ctx context.Context, claimRefID string, req RegisterClaimRequest) (*TransactionResult, error) {
+	if errs := req.Validate(); len(errs) > 0 {
+		return nil, errs
+	}
+	if _, ok := claims.insurers[req.InsurerID]; !ok {
+		return nil, ValidationErrors{{Field: "insurerID", Message: "is not a registered insurer"}}
+	}
+	if _, err := s.GetIncident(ctx, req.IncidentID); err != nil {
+		return nil, err
+	}
+	evidenceIDs := ""
+	if len(req.EvidenceIDs) > 0 {
+		data, err := json.Marshal(req.EvidenceIDs)
+		if err != nil {
+			return nil, err
+		}
+		evidenceIDs = string(data)
+	}
+	return submitTransaction(ctx, "RegisterClaimReference", claimRefID, req.IncidentID, req.InsurerID, claimNumberHash(req.InsurerID, req.ClaimNumber), evidenceIDs, req.Actor)
+}
+
+// readClaimReference reads a claim reference from the peers. The organization that registered it
+// can read it, as can any organization that can read its incident.
+func (s ledgerService) readClaimReference(ctx context.Context, claimRefID string, read func(ctx context.Context, name, id string) ([]byte, error)) (ClaimReference, error) {
+	if errs := validateDocumentID("id", claimRefID); len(errs) > 0 {
+		return ClaimReference{}, errs
+	}
+	result, err := read(ctx, "ReadClaimReference", claimRefID)
+	if err != nil {
+		return ClaimReference{}, err
+	}
+	claim, err := decodeDocument[ClaimReference](result, "claim reference")
+	if err != nil {
+		return ClaimReference{}, err
+	}
+	if !tenantFromContext(ctx).owns(claim.OwnerOrg) {
+		if _, err := s.GetIncident(ctx, claim.IncidentID); err != nil {
+			return ClaimReference{}, fmt.Errorf("the claim reference %s does not exist", claimRefID)
+		}
+	}
+	return claim, nil
+}
+
+func (s ledgerService) GetClaimReference(ctx context.Context, claimRefID string) (ClaimReference, error) {
+	return s.readClaimReference(ctx, claimRefID, readDocument)
+}
+
+// ListClaimReferences returns the claim references registered against an incident, latest first
+func (s ledgerService) ListClaimReferences(ctx context.Context, incidentID string) ([]ClaimReference, error) {
+	if _, err := s.GetIncident(ctx, incidentID); err != nil {
+		return nil, err
+	}
+	result, err := evaluateTransaction(ctx, "GetClaimReferencesByIncident", incidentID)
+	if err != nil {
+		return nil, err
+	}
+	list, err := decodeDocument[[]ClaimReference](result, "claim reference")
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].RegisteredAt > list[j].RegisteredAt })
+	return list, nil
+}
+
+// VerifyClaimReference checks a claim reference against the committed incident and evidence. Like
+// DID verification it reads from the peers rather than the cache or the off-chain index.
+func (s ledgerService) VerifyClaimReference(ctx context.Context, claimRefID string, req ClaimVerificationRequest) (ClaimVerification, error) {
+	if errs := req.Validate(); len(errs) > 0 {
+		return ClaimVerification{}, errs
+	}
+	claim, err := s.readClaimReference(ctx, claimRefID, func(ctx context.Context, name, id string) ([]byte, error) {
+		return evaluateTransaction(ctx, name, id)
+	})
+	if err != nil {
+		return ClaimVerification{}, err
+	}
+	var incident *IncidentDocument
+	result, err := evaluateTransaction(ctx, "ReadIncident", claim.IncidentID)
+	if err != nil && translateFabricError(err).Code != errCodeNotFound {
+		return ClaimVerification{}, err
+	}
+	if err == nil {
+		doc, err := decodeDocument[IncidentDocument](result, "incident")
+		if err != nil {
+			return ClaimVerification{}, err
+		}
+		incident = &doc
+	}
+	current, err := evidenceByIncidentFromLedger(ctx, claim.IncidentID, EvidenceListRequest{})
+	if err != nil {
+		return ClaimVerification{}, err
+	}
+	verdict := checkClaim(claim, incident, current, req.ClaimNumber)
+	verdict.VerifiedAt = time.Now().UTC().Format(time.RFC3339)
+	return verdict, nil
+}
+
+func registerClaimReference(c *gin.Context) {
+	if claims == nil {
+		respondError(c, http.StatusServiceUnavailable, errCodeClaimsDisabled, "Insurers are not configured; set INSURERS_FILE")
+		return
+	}
+	var req RegisterClaimRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+	claimRefID := newClaimRefID(time.Now())
+	setAuditTarget(c, claimRefID)
+
+	result, err := ledger.RegisterClaimReference(c.Request.Context(), claimRefID, req)
+	if err != nil {
+		respondServiceError(c, "Failed to register claim reference", err)
+		return
+	}
+	respondCommitted(c, http.StatusCreated, gin.H{
+		"message":    "Claim reference registered successfully",
+		"claimRefID": claimRefID,
+		"incidentID": req.IncidentID,
+		"insurerID":  req.InsurerID,
+	}, result)
+}
+
+func getClaimReference(c *gin.Context) {
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	claim, err := ledger.GetClaimReference(c.Request.Context(), id)
+	if err != nil {
+		respondServiceError(c, "Failed to read claim reference", err)
+		return
+	}
+	respondTagged(c, claim, claim.TxID)
+}
+
+func listClaimReferences(c *gin.Context) {
+	id, ok := validPathID(c, "incidentId")
+	if !ok {
+		return
+	}
+	list, err := ledger.ListClaimReferences(c.Request.Context(), id)
+	if err != nil {
+		respondServiceError(c, "Failed to list claim references", err)
+		return
+	}
+	respondData(c, http.StatusOK, list)
+}
+
+// verifyClaimReference is the insurer's check that the incident and evidence behind a claim are
+// unchanged
+func verifyClaimReference(c *gin.Context) {
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	var req ClaimVerificationRequest
+	if !bindQuery(c, &req) {
+		return
+	}
+	verdict, err := ledger.VerifyClaimReference(c.Request.Context(), id, req)
+	if err != nil {
+		respondServiceError(c, "Failed to verify claim reference", err)
+		return
+	}
+	respondData(c, http.StatusOK, verdict)
+}
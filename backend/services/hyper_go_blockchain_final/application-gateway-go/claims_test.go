@@ -0,0 +1,91 @@
+package gateway
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckClaim(t *testing.T) {
+	hash := func(c string) string { return strings.Repeat(c, 64) }
+	evidence := []ClaimEvidence{{EvidenceID: "EV-2", EvidenceHash: hash("b")}, {EvidenceID: "EV-1", EvidenceHash: hash("a")}}
+	claim := ClaimReference{
+		ClaimRefID: "CLAIM-1", IncidentID: "INC-1", InsurerID: "acko", ClaimNumberHash: claimNumberHash("acko", "CLM/2025/00042"),
+		IncidentSummaryHash: hash("c"), Evidence: evidence, EvidenceDigest: claimEvidenceDigest(evidence),
+	}
+	incident := &IncidentDocument{IncidentID: "INC-1", IncidentSummaryHash: hash("c"), Status: "resolved"}
+	current := []EvidenceDocument{{EvidenceID: "EV-1", EvidenceHash: hash("a")}, {EvidenceID: "EV-2", EvidenceHash: hash("b")}, {EvidenceID: "EV-3", EvidenceHash: hash("d")}}
+
+	verdict := checkClaim(claim, incident, current, " CLM/2025/00042")
+	if !verdict.Valid || !verdict.DigestValid || !verdict.IncidentUnchanged || verdict.ClaimNumberMatches == nil || !*verdict.ClaimNumberMatches {
+		t.Errorf("expected the claim to verify, got %+v", verdict)
+	}
+	if claimEvidenceDigest([]ClaimEvidence{evidence[1], evidence[0]}) != claim.EvidenceDigest {
+		t.Error("expected the digest independent of the listed order")
+	}
+
+	if v := checkClaim(claim, incident, current, "CLM/2025/00043"); v.Valid || *v.ClaimNumberMatches {
+		t.Errorf("expected another claim number to fail, got %+v", v)
+	}
+	changed := []EvidenceDocument{{EvidenceID: "EV-1", EvidenceHash: hash("e")}}
+	v := checkClaim(claim, incident, changed, "")
+	if v.Valid || v.ClaimNumberMatches != nil || v.Evidence[0].Status != claimEvidenceMissing || v.Evidence[1].Status != claimEvidenceChanged {
+		t.Errorf("expected a changed and a missing item, got %+v", v)
+	}
+	if v := checkClaim(claim, nil, current, ""); v.Valid || v.IncidentFound {
+		t.Errorf("expected a deleted incident to fail, got %+v", v)
+	}
+	edited := *incident
+	edited.IncidentSummaryHash = hash("f")
+	if v := checkClaim(claim, &edited, current, ""); v.Valid || !v.IncidentFound || v.IncidentUnchanged {
+		t.Errorf("expected an edited incident to fail, got %+v", v)
+	}
+	tampered := claim
+	tampered.Evidence = evidence[:1]
+	if v := checkClaim(tampered, incident, current, ""); v.Valid || v.DigestValid {
+		t.Errorf("expected an evidence list not matching its digest to fail, got %+v", v)
+	}
+}
+
+func TestReadInsurers(t *testing.T) {
+	write := func(content string) string {
+		path := filepath.Join(t.TempDir(), "insurers.json")
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	insurers, err := readInsurers(write(`[{"insurer_id":"acko","name":"Acko General Insurance"}]`))
+	if err != nil || insurers["acko"].Name != "Acko General Insurance" {
+		t.Fatalf("unexpected insurers %+v %v", insurers, err)
+	}
+	for name, content := range map[string]string{
+		"duplicate": `[{"insurer_id":"acko","name":"A"},{"insurer_id":"acko","name":"B"}]`,
+		"invalid":   `[{"insurer_id":"acko general","name":"A"}]`,
+		"unnamed":   `[{"insurer_id":"acko"}]`,
+	} {
+		if _, err := readInsurers(write(content)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestRegisterClaimRequestValidation(t *testing.T) {
+	valid := RegisterClaimRequest{IncidentID: "INC-1", InsurerID: "acko", ClaimNumber: "CLM/2025/00042", EvidenceIDs: []string{"EV-1"}, Actor: "claims_desk"}
+	if errs := valid.Validate(); len(errs) > 0 {
+		t.Fatalf("unexpected errors %v", errs)
+	}
+	cases := map[string]func(r *RegisterClaimRequest){
+		"claimNumber":    func(r *RegisterClaimRequest) { r.ClaimNumber = "CLM\n42" },
+		"evidenceIDs[1]": func(r *RegisterClaimRequest) { r.EvidenceIDs = []string{"EV-1", "EV-1"} },
+		"insurerID":      func(r *RegisterClaimRequest) { r.InsurerID = "" },
+	}
+	for field, change := range cases {
+		req := valid
+		change(&req)
+		if errs := req.Validate(); len(errs) != 1 || errs[0].Field != field {
+			t.Errorf("expected %s rejected, got %v", field, errs)
+		}
+	}
+}
@@ -0,0 +1,1344 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	cliConfigEnv  = "SIH_CLI_CONFIG"
+	cliProfileEnv = "SIH_PROFILE"
+
+	cliOutputTable = "table"
+	cliOutputJSON  = "json"
+
+	// Nested values in a table cell are shown as JSON cut to this many characters
+	maxCLICellLength = 60
+)
+
+// cliConfig is the operator's profile file. Each profile is an identity on a gateway: the API key
+// or client certificate it authenticates with and the name it signs ledger records as.
+type cliConfig struct {
+	Current  string                 `json:"current,omitempty"`
+	Profiles map[string]*cliProfile `json:"profiles"`
+
+	path string
+}
+
+type cliProfile struct {
+	URL string `json:"url"`
+	// APIKeyEnv names an environment variable holding the key, so the file need not contain it
+	APIKey     string `json:"apiKey,omitempty"`
+	APIKeyEnv  string `json:"apiKeyEnv,omitempty"`
+	ClientCert string `json:"clientCert,omitempty"`
+	ClientKey  string `json:"clientKey,omitempty"`
+	CACert     string `json:"caCert,omitempty"`
+	// Actor is the default for the actor, updater, reporter and similar fields of write commands
+	Actor    string      `json:"actor,omitempty"`
+	Language string      `json:"language,omitempty"`
+	Output   string      `json:"output,omitempty"`
+	Session  *cliSession `json:"session,omitempty"`
+}
+
+// cliSession is the access and refresh token pair from login
+type cliSession struct {
+	AccessToken      string `json:"accessToken"`
+	RefreshToken     string `json:"refreshToken"`
+	RefreshExpiresAt string `json:"refreshExpiresAt"`
+	SessionID        string `json:"sessionID"`
+}
+
+func defaultCLIConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = "."
+	}
+	return filepath.Join(dir, "sih", "cli.json")
+}
+
+func loadCLIConfig(filename string) (*cliConfig, error) {
+	config := &cliConfig{Profiles: map[string]*cliProfile{}, path: filename}
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read profiles: %w", err)
+	}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("invalid profiles file %s: %w", filename, err)
+	}
+	if config.Profiles == nil {
+		config.Profiles = map[string]*cliProfile{}
+	}
+	return config, nil
+}
+
+// save writes the profiles readable only by the operator, since they can hold keys and sessions
+func (c *cliConfig) save() error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(c.path, append(data, '\n'), 0o600)
+}
+
+// profile picks the named profile, else SIH_PROFILE, else the current one
+func (c *cliConfig) profile(name string) (string, *cliProfile, error) {
+	name = cmp.Or(name, os.Getenv(cliProfileEnv), c.Current)
+	if name == "" {
+		return "", nil, fmt.Errorf("no profile selected; create one with 'sih-cli profile set <name> --url ...'")
+	}
+	p, ok := c.Profiles[name]
+	if !ok {
+		return "", nil, fmt.Errorf("profile %q is not in %s", name, c.path)
+	}
+	return name, p, nil
+}
+
+func (p *cliProfile) apiKey() string {
+	if p.APIKeyEnv != "" {
+		return os.Getenv(p.APIKeyEnv)
+	}
+	return p.APIKey
+}
+
+// cliClient sends requests to the gateway as one profile, refreshing its session when the access
+// token has expired
+type cliClient struct {
+	config  *cliConfig
+	name    string
+	profile *cliProfile
+	http    *http.Client
+}
+
+func newCLIClient(config *cliConfig, name string, profile *cliProfile) (*cliClient, error) {
+	if _, err := url.Parse(profile.URL); err != nil || !strings.HasPrefix(profile.URL, "http") {
+		return nil, fmt.Errorf("profile %s: url must be an http or https URL", name)
+	}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if profile.CACert != "" {
+		pem, err := os.ReadFile(profile.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", profile.CACert)
+		}
+	}
+	if profile.ClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(profile.ClientCert, profile.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &cliClient{config: config, name: name, profile: profile, http: &http.Client{Transport: transport}}, nil
+}
+
+// cliRequest is one gateway call. Body is reopened if the call is retried after a refresh.
+type cliRequest struct {
+	method      string
+	path        string
+	query       url.Values
+	body        func() (io.Reader, string, error)
+	accept      string
+	idempotency string
+}
+
+// cliAPIError is a failed envelope, or a non-JSON error response
+type cliAPIError struct {
+	status int
+	APIError
+}
+
+func (e *cliAPIError) Error() string {
+	message := fmt.Sprintf("%d %s: %s", e.status, e.Code, e.Message)
+	for _, field := range e.Fields {
+		message += fmt.Sprintf("\n  %s: %s", field.Field, field.Message)
+	}
+	return message
+}
+
+// url resolves a path against the gateway: /api/v1 is added unless the path already names a
+// top-level route such as /health
+func (c *cliClient) url(path string, query url.Values) string {
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	if !strings.HasPrefix(path, "/api/") && !strings.HasPrefix(path, "/health") && !strings.HasPrefix(path, "/metrics") {
+		path = "/api/v1" + path
+	}
+	u := strings.TrimSuffix(c.profile.URL, "/") + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	return u
+}
+
+func (c *cliClient) send(ctx context.Context, r cliRequest) (*http.Response, error) {
+	var body io.Reader
+	contentType := ""
+	if r.body != nil {
+		var err error
+		if body, contentType, err = r.body(); err != nil {
+			return nil, err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, r.method, c.url(r.path, r.query), body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("Accept", cmp.Or(r.accept, "application/json"))
+	if r.idempotency != "" {
+		req.Header.Set(idempotencyHeader, r.idempotency)
+	}
+	if c.profile.Language != "" {
+		req.Header.Set("Accept-Language", c.profile.Language)
+	}
+	if c.profile.Session != nil {
+		req.Header.Set("Authorization", "Bearer "+c.profile.Session.AccessToken)
+	} else if key := c.profile.apiKey(); key != "" {
+		req.Header.Set(apiKeyHeader, key)
+	}
+	return c.http.Do(req)
+}
+
+// do sends a request and, when the session's access token has expired, refreshes it once and
+// sends the request again with the same idempotency key
+func (c *cliClient) do(ctx context.Context, r cliRequest) (*http.Response, error) {
+	if r.idempotency == "" && r.method != http.MethodGet && r.method != http.MethodHead {
+		r.idempotency = newIdempotencyKey()
+	}
+	resp, err := c.send(ctx, r)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || c.profile.Session == nil {
+		return resp, err
+	}
+	failure := readCLIError(resp)
+	if failure.Code != errCodeTokenExpired {
+		return nil, failure
+	}
+	if err := c.refresh(ctx); err != nil {
+		return nil, fmt.Errorf("session expired and could not be refreshed, run 'sih-cli login' again: %w", err)
+	}
+	return c.send(ctx, r)
+}
+
+func (c *cliClient) refresh(ctx context.Context) error {
+	var tokens TokenResponse
+	body, _ := json.Marshal(RefreshTokenRequest{RefreshToken: c.profile.Session.RefreshToken})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url("/auth/refresh", nil), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	if _, err := decodeCLIEnvelope(resp, &tokens); err != nil {
+		return err
+	}
+	c.profile.Session = sessionFromTokens(tokens)
+	return c.config.save()
+}
+
+func sessionFromTokens(tokens TokenResponse) *cliSession {
+	return &cliSession{AccessToken: tokens.AccessToken, RefreshToken: tokens.RefreshToken, RefreshExpiresAt: tokens.RefreshExpiresAt, SessionID: tokens.SessionID}
+}
+
+func newIdempotencyKey() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// readCLIError turns an error response into a cliAPIError, keeping the body of one that is not an
+// envelope as the message
+func readCLIError(resp *http.Response) *cliAPIError {
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	var envelope APIResponse
+	if json.Unmarshal(data, &envelope) == nil && envelope.Error != nil {
+		return &cliAPIError{status: resp.StatusCode, APIError: *envelope.Error}
+	}
+	return &cliAPIError{status: resp.StatusCode, APIError: APIError{Code: http.StatusText(resp.StatusCode), Message: strings.TrimSpace(string(data))}}
+}
+
+// cliEnvelope is APIResponse with its data kept undecoded
+type cliEnvelope struct {
+	Success     bool            `json:"success"`
+	Data        json.RawMessage `json:"data,omitempty"`
+	TxID        string          `json:"tx_id,omitempty"`
+	BlockNumber *uint64         `json:"block_number,omitempty"`
+	Timestamp   string          `json:"timestamp"`
+}
+
+// decodeCLIEnvelope reads a JSON envelope, decoding its data into v when v is not nil
+func decodeCLIEnvelope(resp *http.Response, v interface{}) (*cliEnvelope, error) {
+	if resp.StatusCode >= 300 {
+		return nil, readCLIError(resp)
+	}
+	defer resp.Body.Close()
+	var envelope cliEnvelope
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		return nil, fmt.Errorf("unexpected response from the gateway: %w", err)
+	}
+	if v != nil {
+		if err := json.Unmarshal(envelope.Data, v); err != nil {
+			return nil, fmt.Errorf("unexpected response data: %w", err)
+		}
+	}
+	return &envelope, nil
+}
+
+// Field kinds
+const (
+	cliString = iota
+	cliInt
+	cliFloat
+	// cliFileHash takes a path and sends the SHA-256 of the file
+	cliFileHash
+	// cliAccept is a boolean that asks for the given media type instead of JSON
+	cliAccept
+)
+
+// cliField maps a flag to a JSON body field or a query parameter
+type cliField struct {
+	flag  string
+	key   string
+	usage string
+	kind  int
+	// actor fields default to the profile's actor
+	actor bool
+	// media is the type a cliAccept flag asks for
+	media string
+}
+
+func cliStr(flag, key, usage string) cliField {
+	return cliField{flag: flag, key: key, usage: usage}
+}
+
+func cliNum(flag, key, usage string) cliField {
+	return cliField{flag: flag, key: key, usage: usage, kind: cliFloat}
+}
+
+func cliCount(flag, key, usage string) cliField {
+	return cliField{flag: flag, key: key, usage: usage, kind: cliInt}
+}
+
+func cliHash(flag, key, usage string) cliField {
+	return cliField{flag: flag, key: key, usage: usage, kind: cliFileHash}
+}
+
+func cliActor(flag, key string) cliField {
+	return cliField{flag: flag, key: key, usage: "who is acting; defaults to the profile's actor", actor: true}
+}
+
+// cliEndpoint is one command: the gateway route it calls, with each :param in the path taken from
+// a positional argument in order
+type cliEndpoint struct {
+	use     string
+	short   string
+	method  string
+	path    string
+	body    []cliField
+	query   []cliField
+	columns []string
+	// upload sends the file named by --file as this multipart field after the body fields
+	upload string
+	// raw sends the file named by --file as the whole body, typed by its extension
+	raw bool
+	// download lets a non-JSON response be written to --out
+	download bool
+}
+
+func (e cliEndpoint) params() []string {
+	var params []string
+	for _, segment := range strings.Split(e.path, "/") {
+		if name, ok := strings.CutPrefix(segment, ":"); ok {
+			params = append(params, name)
+		}
+	}
+	return params
+}
+
+// cliGroups lists the commands for the gateway's routes. Anything missing is reachable with
+// 'sih-cli api'.
+var cliGroups = []struct {
+	name      string
+	short     string
+	endpoints []cliEndpoint
+}{
+	{"did", "Issue, verify and revoke tourist digital IDs", []cliEndpoint{
+		{use: "create", short: "Issue a DID", method: http.MethodPost, path: "/did/", body: []cliField{
+			cliStr("id", "digitalID", "digital ID, such as did:sih:tourist_001"),
+			cliStr("consent-hash", "consentHash", "SHA-256 of the signed consent"),
+			cliHash("consent-file", "consentHash", "signed consent document to hash instead of --consent-hash"),
+			cliStr("expires", "expiresAt", "RFC 3339 expiry"),
+			cliActor("issuer", "issuer"),
+		}},
+		{use: "bulk", short: "Issue DIDs from a CSV or JSON manifest", method: http.MethodPost, path: "/did/bulk", raw: true, download: true,
+			query: []cliField{cliStr("format", "format", "report format: json, csv or xlsx")}, columns: []string{"row", "digital_id", "status", "tx_id", "errors"}},
+		{use: "get", short: "Show a DID", method: http.MethodGet, path: "/did/:id"},
+		{use: "verify", short: "Check a DID is active and unexpired", method: http.MethodGet, path: "/did/:id/verify"},
+		{use: "qr", short: "Download a DID's signed QR code", method: http.MethodGet, path: "/did/:id/qr", download: true, query: []cliField{
+			cliStr("format", "format", "png or svg"),
+			cliCount("scale", "scale", "pixels per module"),
+		}},
+		{use: "verify-qr", short: "Verify a scanned QR payload", method: http.MethodPost, path: "/did/verify-qr", body: []cliField{
+			cliStr("payload", "payload", "the scanned payload"),
+		}},
+		{use: "verify-qr-offline", short: "Verify a scanned QR payload against a revocation list, without the ledger", method: http.MethodPost, path: "/did/verify-qr/offline", body: []cliField{
+			cliStr("payload", "payload", "the scanned payload"),
+			cliStr("revocation-list", "revocationList", "signed revocation list held by the device"),
+		}},
+		{use: "update", short: "Renew a DID's consent and expiry", method: http.MethodPut, path: "/did/:id", body: []cliField{
+			cliStr("consent-hash", "consentHash", "SHA-256 of the signed consent"),
+			cliHash("consent-file", "consentHash", "signed consent document to hash instead of --consent-hash"),
+			cliStr("expires", "expiresAt", "RFC 3339 expiry"),
+			cliActor("updater", "updater"),
+		}},
+		{use: "delete", short: "Revoke a DID", method: http.MethodDelete, path: "/did/:id", body: []cliField{cliActor("actor", "actor")}},
+	}},
+	{"incident", "Record and manage incidents", []cliEndpoint{
+		{use: "create", short: "Record an incident", method: http.MethodPost, path: "/incident/", body: []cliField{
+			cliStr("id", "incidentID", "incident ID"),
+			cliStr("summary-hash", "incidentSummaryHash", "SHA-256 of the incident summary"),
+			cliHash("summary-file", "incidentSummaryHash", "summary document to hash instead of --summary-hash"),
+			cliActor("reporter", "reporter"),
+			cliStr("severity", "severity", "low, medium, high or critical"),
+			cliStr("category", "category", "missing_person, medical, accident, theft, harassment, anomaly, geofence or other"),
+			cliStr("digital-id", "digitalID", "the tourist the incident concerns"),
+			cliNum("lat", "latitude", "latitude"),
+			cliNum("lng", "longitude", "longitude"),
+		}},
+		{use: "search", short: "Search incidents", method: http.MethodGet, path: "/incident", query: []cliField{
+			cliStr("from", "from", "RFC 3339 start"),
+			cliStr("to", "to", "RFC 3339 end"),
+			cliStr("status", "status", "open, acknowledged, resolved or closed"),
+			cliStr("severity", "severity", "low, medium, high or critical"),
+			cliStr("reporter", "reporter", "reporter"),
+			cliCount("page-size", "page_size", "results per page"),
+			cliStr("bookmark", "bookmark", "bookmark of the next page"),
+		}, columns: []string{"incident_id", "status", "severity", "category", "reporter", "created_at"}},
+		{use: "export", short: "Export incidents in a period", method: http.MethodGet, path: "/incident/export", download: true, query: []cliField{
+			cliStr("from", "from", "RFC 3339 start"),
+			cliStr("to", "to", "RFC 3339 end"),
+			cliStr("status", "status", "status"),
+			cliStr("severity", "severity", "severity"),
+			cliStr("reporter", "reporter", "reporter"),
+			cliStr("format", "format", "json, csv or xlsx"),
+		}},
+		{use: "get", short: "Show an incident", method: http.MethodGet, path: "/incident/:id"},
+		{use: "update", short: "Replace an incident's summary hash", method: http.MethodPut, path: "/incident/:id", body: []cliField{
+			cliStr("summary-hash", "incidentSummaryHash", "SHA-256 of the incident summary"),
+			cliHash("summary-file", "incidentSummaryHash", "summary document to hash instead of --summary-hash"),
+			cliActor("updater", "updater"),
+		}},
+		{use: "status", short: "Move an incident to another status", method: http.MethodPut, path: "/incident/:id/status", body: []cliField{
+			cliStr("status", "status", "open, acknowledged, resolved or closed"),
+			cliActor("updater", "updater"),
+		}},
+		{use: "changes", short: "List changes to an incident", method: http.MethodGet, path: "/incident/:id/changes"},
+		{use: "delete", short: "Delete an incident", method: http.MethodDelete, path: "/incident/:id", body: []cliField{cliActor("actor", "actor")}},
+		{use: "efir", short: "Generate the incident's e-FIR", method: http.MethodPost, path: "/incident/:id/efir", download: true, body: []cliField{
+			cliActor("generated-by", "generatedBy"),
+			cliStr("station", "policeStation", "police station"),
+			cliStr("district", "district", "district"),
+			cliStr("complainant", "complainant", "complainant"),
+			cliStr("description", "description", "description"),
+			cliStr("state", "state", "state whose layout to use"),
+			{flag: "pdf", usage: "download the signed PDF instead of JSON", kind: cliAccept, media: "application/pdf"},
+		}},
+		{use: "timeline", short: "Show everything that happened to an incident in order", method: http.MethodGet, path: "/incident/:id/timeline", query: []cliField{
+			cliStr("source", "source", "only entries from ledger, audit, evidence, location, dispatch or notification"),
+		}, columns: []string{"at", "source", "type", "summary", "tx_id"}},
+		{use: "annexure", short: "Generate the incident's timeline as a court annexure", method: http.MethodPost, path: "/incident/:id/timeline/annexure", download: true, body: []cliField{
+			cliActor("generated-by", "generatedBy"),
+			cliStr("case-reference", "caseReference", "court or FIR reference to print on it"),
+			{flag: "pdf", usage: "download the PDF instead of JSON", kind: cliAccept, media: "application/pdf"},
+		}},
+		{use: "annexures", short: "List the incident's timeline annexures", method: http.MethodGet, path: "/incident/:id/timeline/annexures",
+			columns: []string{"annexure_id", "entry_count", "generated_by", "created_at", "tx_id"}},
+	}},
+	{"evidence", "Anchor, upload and download evidence", []cliEndpoint{
+		{use: "anchor", short: "Anchor the hash of evidence kept elsewhere", method: http.MethodPost, path: "/evidence/", body: []cliField{
+			cliStr("id", "evidenceID", "evidence ID"),
+			cliStr("incident", "incidentID", "incident the evidence belongs to"),
+			cliStr("hash", "evidenceHash", "SHA-256 of the evidence"),
+			cliHash("hash-file", "evidenceHash", "evidence file to hash instead of --hash"),
+			cliStr("media-type", "mediaType", "media type, such as image/jpeg"),
+			cliActor("uploaded-by", "uploadedBy"),
+		}},
+		{use: "upload", short: "Upload evidence to the evidence store and anchor it", method: http.MethodPost, path: "/evidence/upload", upload: uploadFileField, body: []cliField{
+			cliStr("id", "evidenceID", "evidence ID"),
+			cliStr("incident", "incidentID", "incident the evidence belongs to"),
+			cliActor("uploaded-by", "uploadedBy"),
+		}},
+		{use: "get", short: "Show evidence", method: http.MethodGet, path: "/evidence/:id"},
+		{use: "download", short: "Download an evidence file", method: http.MethodGet, path: "/evidence/:id/download", download: true},
+		{use: "update", short: "Replace evidence's hash", method: http.MethodPut, path: "/evidence/:id", body: []cliField{
+			cliStr("hash", "evidenceHash", "SHA-256 of the evidence"),
+			cliHash("hash-file", "evidenceHash", "evidence file to hash instead of --hash"),
+			cliStr("media-type", "mediaType", "media type"),
+			cliActor("updater", "updater"),
+		}},
+		{use: "delete", short: "Delete evidence", method: http.MethodDelete, path: "/evidence/:id", body: []cliField{cliActor("actor", "actor")}},
+		{use: "list", short: "List an incident's evidence", method: http.MethodGet, path: "/evidence/incident/:incidentId", query: []cliField{
+			cliStr("media-type", "media_type", "media type"),
+			cliStr("uploader", "uploader", "uploader"),
+			cliStr("from", "from", "RFC 3339 start"),
+			cliStr("to", "to", "RFC 3339 end"),
+			cliStr("sort", "sort", "created_at, media_type or uploaded_by, descending with a leading -"),
+		}, columns: []string{"evidence_id", "media_type", "uploaded_by", "created_at", "evidence_hash"}},
+	}},
+	{"sos", "Raise SOS alerts", []cliEndpoint{
+		{use: "raise", short: "Raise an SOS alert for a tourist", method: http.MethodPost, path: "/sos", body: []cliField{
+			cliStr("digital-id", "digitalID", "tourist raising the alert"),
+			cliNum("lat", "latitude", "latitude"),
+			cliNum("lng", "longitude", "longitude"),
+			cliNum("accuracy", "accuracy", "accuracy in meters"),
+			cliStr("message", "message", "message"),
+			cliStr("source", "source", "app, sms, kiosk, wearable, geofence or chat"),
+			cliStr("id", "alertID", "alert ID; one is generated otherwise"),
+		}},
+	}},
+	{"audit", "Query the audit trail", []cliEndpoint{
+		{use: "search", short: "Search audit entries", method: http.MethodGet, path: "/audit", query: []cliField{
+			cliStr("actor", "actor", "actor"),
+			cliStr("action", "action", "action, such as CREATE_INCIDENT"),
+			cliStr("from", "from", "RFC 3339 start"),
+			cliStr("to", "to", "RFC 3339 end"),
+			cliCount("page-size", "page_size", "results per page"),
+			cliStr("bookmark", "bookmark", "bookmark of the next page"),
+		}, columns: []string{"timestamp", "action", "target_id", "actor", "submitter", "tx_id"}},
+		{use: "target", short: "List audit entries for a record", method: http.MethodGet, path: "/audit/:targetId",
+			columns: []string{"timestamp", "action", "actor", "submitter", "tx_id"}},
+		{use: "export", short: "Export audit entries in a period", method: http.MethodGet, path: "/audit/export", download: true, query: []cliField{
+			cliStr("actor", "actor", "actor"),
+			cliStr("action", "action", "action"),
+			cliStr("from", "from", "RFC 3339 start"),
+			cliStr("to", "to", "RFC 3339 end"),
+			cliStr("format", "format", "json, csv or xlsx"),
+		}},
+	}},
+	{"consent", "Manage tourist consents", []cliEndpoint{
+		{use: "list", short: "List a tourist's consents", method: http.MethodGet, path: "/consents/:id"},
+		{use: "check", short: "Check a consent is active", method: http.MethodGet, path: "/consents/:id/check", query: []cliField{
+			cliStr("purpose", "purpose", "location_tracking, family_sharing or data_retention"),
+		}},
+		{use: "history", short: "Show a tourist's consent history", method: http.MethodGet, path: "/consents/:id/history"},
+		{use: "grant", short: "Grant a consent", method: http.MethodPost, path: "/consents/:id/grant", body: []cliField{
+			cliStr("purpose", "purpose", "location_tracking, family_sharing or data_retention"),
+			cliStr("policy-version", "policyVersion", "privacy policy version"),
+			cliStr("expires", "expiresAt", "RFC 3339 expiry"),
+			cliActor("actor", "actor"),
+		}},
+		{use: "renew", short: "Renew a consent", method: http.MethodPost, path: "/consents/:id/renew", body: []cliField{
+			cliStr("purpose", "purpose", "purpose"),
+			cliStr("expires", "expiresAt", "RFC 3339 expiry"),
+			cliActor("actor", "actor"),
+		}},
+		{use: "withdraw", short: "Withdraw a consent", method: http.MethodPost, path: "/consents/:id/withdraw", body: []cliField{
+			cliStr("purpose", "purpose", "purpose"),
+			cliActor("actor", "actor"),
+		}},
+		{use: "receipts", short: "List a tourist's consent receipts", method: http.MethodGet, path: "/consents/:id/receipts", query: []cliField{
+			cliStr("purpose", "purpose", "purpose"),
+		}},
+		{use: "receipt", short: "Download a verified consent receipt", method: http.MethodGet, path: "/consents/:id/receipts/:receiptId", download: true, query: []cliField{
+			cliStr("format", "format", "json or pdf"),
+		}},
+		{use: "issue-receipt", short: "Issue the receipt for a consent's current version again", method: http.MethodPost, path: "/consents/:id/receipts", body: []cliField{
+			cliStr("purpose", "purpose", "purpose"),
+			cliActor("actor", "actor"),
+		}},
+	}},
+	{"location", "Read tourists' locations", []cliEndpoint{
+		{use: "live", short: "Show a tourist's latest location", method: http.MethodGet, path: "/location/:digitalId"},
+		{use: "anchors", short: "List a tourist's anchored location batches", method: http.MethodGet, path: "/location/:digitalId/anchors"},
+	}},
+	{"dispatch", "Work with responder units and assignments", []cliEndpoint{
+		{use: "assignments", short: "List assignments for an alert or incident", method: http.MethodGet, path: "/dispatch/assignments", query: []cliField{
+			cliStr("subject", "subject", "alert or incident ID"),
+		}},
+		{use: "ack", short: "Acknowledge an assignment", method: http.MethodPost, path: "/dispatch/assignments/:id/acknowledge", body: []cliField{
+			cliStr("unit", "unitID", "acknowledging unit"),
+		}},
+		{use: "decline", short: "Decline an assignment", method: http.MethodPost, path: "/dispatch/assignments/:id/decline", body: []cliField{
+			cliStr("unit", "unitID", "declining unit"),
+			cliStr("reason", "reason", "reason"),
+		}},
+		{use: "units", short: "List responder units", method: http.MethodGet, path: "/dispatch/units"},
+		{use: "nearest", short: "Find the nearest available units", method: http.MethodGet, path: "/dispatch/units/nearest", query: []cliField{
+			cliNum("lat", "latitude", "latitude"),
+			cliNum("lng", "longitude", "longitude"),
+			cliStr("kind", "kind", "unit kind"),
+			cliStr("capabilities", "capabilities", "comma-separated capabilities"),
+			cliCount("limit", "limit", "most units to return"),
+		}},
+		{use: "unit-status", short: "Set a unit's status", method: http.MethodPut, path: "/dispatch/units/:id/status", body: []cliField{
+			cliStr("status", "status", "new status"),
+		}},
+	}},
+	{"erasure", "Erase tourists' personal data", []cliEndpoint{
+		{use: "request", short: "Erase a tourist's off-chain personal data", method: http.MethodPost, path: "/erasures", body: []cliField{
+			cliStr("digital-id", "digitalID", "tourist"),
+			cliStr("verification", "verification", "how the request was verified: document or officer"),
+			cliStr("document-type", "documentType", "identity document type"),
+			cliStr("document-number", "documentNumber", "identity document number"),
+			cliStr("nationality", "nationality", "nationality"),
+			cliStr("verified-by", "verifiedBy", "officer who verified the request"),
+			cliActor("requested-by", "requestedBy"),
+		}},
+		{use: "list", short: "List a tourist's erasures", method: http.MethodGet, path: "/erasures", query: []cliField{
+			cliStr("digital-id", "digitalID", "tourist"),
+		}},
+		{use: "certificate", short: "Show an erasure certificate", method: http.MethodGet, path: "/erasures/:id/certificate"},
+	}},
+	{"report", "Generate and download operations reports", []cliEndpoint{
+		{use: "generate", short: "Generate a report", method: http.MethodPost, path: "/reports", body: []cliField{
+			cliStr("period", "period", "daily or weekly"),
+			cliStr("date", "date", "date the period ends, YYYY-MM-DD"),
+		}},
+		{use: "list", short: "List reports", method: http.MethodGet, path: "/reports", query: []cliField{
+			cliStr("period", "period", "daily or weekly"),
+		}},
+		{use: "download", short: "Download a report", method: http.MethodGet, path: "/reports/:id/:format", download: true},
+	}},
+	{"ledger", "Inspect blocks and transactions", []cliEndpoint{
+		{use: "block", short: "Show a block", method: http.MethodGet, path: "/ledger/blocks/:number"},
+		{use: "tx", short: "Show a transaction", method: http.MethodGet, path: "/ledger/tx/:txid"},
+		{use: "tx-status", short: "Show whether a transaction committed", method: http.MethodGet, path: "/tx/:txid/status"},
+	}},
+	{"stats", "Dashboard figures", []cliEndpoint{
+		{use: "summary", short: "Show dashboard statistics", method: http.MethodGet, path: "/stats"},
+		{use: "counts", short: "Show live counts", method: http.MethodGet, path: "/dashboard/counts"},
+		{use: "health", short: "Show the gateway's health", method: http.MethodGet, path: "/health"},
+	}},
+}
+
+// cliOptions are the flags shared by every command
+type cliOptions struct {
+	configFile string
+	profile    string
+	output     string
+	columns    []string
+	timeout    time.Duration
+	out        io.Writer
+}
+
+// RunCLI is the sih-cli entry point
+func RunCLI() {
+	cmd := newCLICommand(os.Stdout)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := cmd.ExecuteContext(ctx); err != nil {
+		stop()
+		os.Exit(1)
+	}
+}
+
+func newCLICommand(out io.Writer) *cobra.Command {
+	opts := &cliOptions{out: out}
+	root := &cobra.Command{
+		Use:          "sih-cli",
+		Short:        "Operate the tourist safety gateway from the command line",
+		SilenceUsage: true,
+	}
+	root.SetOut(out)
+	root.PersistentFlags().StringVar(&opts.configFile, "config", getEnv(cliConfigEnv, defaultCLIConfigPath()), "profiles file")
+	root.PersistentFlags().StringVarP(&opts.profile, "profile", "p", "", "profile to act as (default $SIH_PROFILE, then the current profile)")
+	root.PersistentFlags().StringVarP(&opts.output, "output", "o", "", "table or json (default the profile's output, then table)")
+	root.PersistentFlags().StringSliceVar(&opts.columns, "columns", nil, "columns to show in table output")
+	root.PersistentFlags().DurationVar(&opts.timeout, "timeout", time.Minute, "time allowed for each request")
+
+	for _, group := range cliGroups {
+		parent := &cobra.Command{Use: group.name, Short: group.short}
+		for _, e := range group.endpoints {
+			parent.AddCommand(newEndpointCommand(opts, e))
+		}
+		root.AddCommand(parent)
+	}
+	root.AddCommand(newAPICommand(opts), newProfileCommand(opts), newLoginCommand(opts), newLogoutCommand(opts))
+	return root
+}
+
+// client loads the profiles and returns a client for the selected one
+func (o *cliOptions) client() (*cliClient, error) {
+	config, err := loadCLIConfig(o.configFile)
+	if err != nil {
+		return nil, err
+	}
+	name, profile, err := config.profile(o.profile)
+	if err != nil {
+		return nil, err
+	}
+	return newCLIClient(config, name, profile)
+}
+
+func (o *cliOptions) format(profile *cliProfile) (string, error) {
+	format := cmp.Or(o.output, profile.Output, cliOutputTable)
+	if format != cliOutputTable && format != cliOutputJSON {
+		return "", fmt.Errorf("unknown output %q; expected table or json", format)
+	}
+	return format, nil
+}
+
+func newEndpointCommand(opts *cliOptions, e cliEndpoint) *cobra.Command {
+	params := e.params()
+	use := e.use
+	for _, p := range params {
+		use += " <" + p + ">"
+	}
+	var data, file, outFile string
+	cmd := &cobra.Command{
+		Use:   use,
+		Short: e.short,
+		Args:  cobra.ExactArgs(len(params)),
+	}
+	for _, f := range slices.Concat(e.body, e.query) {
+		switch f.kind {
+		case cliInt:
+			cmd.Flags().Int(f.flag, 0, f.usage)
+		case cliFloat:
+			cmd.Flags().Float64(f.flag, 0, f.usage)
+		case cliAccept:
+			cmd.Flags().Bool(f.flag, false, f.usage)
+		default:
+			cmd.Flags().String(f.flag, "", f.usage)
+		}
+	}
+	if e.method != http.MethodGet && e.upload == "" && !e.raw {
+		cmd.Flags().StringVarP(&data, "data", "d", "", "JSON body, or @file to read it from a file; flags override its fields")
+	}
+	if e.upload != "" || e.raw {
+		cmd.Flags().StringVarP(&file, "file", "f", "", "file to send")
+		cmd.MarkFlagRequired("file")
+	}
+	if e.download {
+		cmd.Flags().StringVar(&outFile, "out", "", "write a file response here instead of standard output")
+	}
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		client, err := opts.client()
+		if err != nil {
+			return err
+		}
+		format, err := opts.format(client.profile)
+		if err != nil {
+			return err
+		}
+		path := e.path
+		for i, p := range params {
+			path = strings.Replace(path, ":"+p, url.PathEscape(args[i]), 1)
+		}
+		req := cliRequest{method: e.method, path: path, query: url.Values{}}
+		for _, f := range e.query {
+			if cmd.Flags().Changed(f.flag) {
+				req.query.Set(f.key, cmd.Flags().Lookup(f.flag).Value.String())
+			}
+		}
+		body, err := endpointBody(cmd, e.body, data, client.profile)
+		if err != nil {
+			return err
+		}
+		for _, f := range e.body {
+			if on, _ := cmd.Flags().GetBool(f.flag); f.kind == cliAccept && on {
+				req.accept = f.media
+			}
+		}
+		switch {
+		case e.upload != "":
+			req.body = multipartBody(body, e.upload, file)
+		case e.raw:
+			req.body = fileBody(file)
+		case e.method != http.MethodGet:
+			encoded, _ := json.Marshal(body)
+			req.body = func() (io.Reader, string, error) { return bytes.NewReader(encoded), "application/json", nil }
+		}
+
+		ctx, cancel := context.WithTimeout(cmd.Context(), opts.timeout)
+		defer cancel()
+		resp, err := client.do(ctx, req)
+		if err != nil {
+			return err
+		}
+		columns := opts.columns
+		if len(columns) == 0 {
+			columns = e.columns
+		}
+		return writeCLIResponse(opts.out, resp, format, columns, outFile)
+	}
+	return cmd
+}
+
+// endpointBody builds the JSON body from --data and the flags that were set. Actor fields nobody
+// set fall back to the profile's actor.
+func endpointBody(cmd *cobra.Command, fields []cliField, data string, profile *cliProfile) (map[string]interface{}, error) {
+	body := map[string]interface{}{}
+	if data != "" {
+		raw := []byte(data)
+		if filename, ok := strings.CutPrefix(data, "@"); ok {
+			var err error
+			if raw, err = os.ReadFile(filename); err != nil {
+				return nil, err
+			}
+		}
+		if err := json.Unmarshal(raw, &body); err != nil {
+			return nil, fmt.Errorf("--data must be a JSON object: %w", err)
+		}
+	}
+	flags := cmd.Flags()
+	for _, f := range fields {
+		if !flags.Changed(f.flag) {
+			if _, set := body[f.key]; f.actor && !set && profile.Actor != "" {
+				body[f.key] = profile.Actor
+			}
+			continue
+		}
+		switch f.kind {
+		case cliInt:
+			body[f.key], _ = flags.GetInt(f.flag)
+		case cliFloat:
+			body[f.key], _ = flags.GetFloat64(f.flag)
+		case cliFileHash:
+			filename, _ := flags.GetString(f.flag)
+			hash, err := fileSHA256(filename)
+			if err != nil {
+				return nil, err
+			}
+			body[f.key] = hash
+		case cliString:
+			body[f.key], _ = flags.GetString(f.flag)
+		}
+	}
+	return body, nil
+}
+
+func fileSHA256(filename string) (string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// multipartBody streams the form fields and then the file, which the upload handler requires
+func multipartBody(fields map[string]interface{}, field, filename string) func() (io.Reader, string, error) {
+	return func() (io.Reader, string, error) {
+		file, err := os.Open(filename)
+		if err != nil {
+			return nil, "", err
+		}
+		pr, pw := io.Pipe()
+		form := multipart.NewWriter(pw)
+		go func() {
+			defer file.Close()
+			keys := make([]string, 0, len(fields))
+			for key := range fields {
+				keys = append(keys, key)
+			}
+			slices.Sort(keys)
+			for _, key := range keys {
+				if err := form.WriteField(key, fmt.Sprint(fields[key])); err != nil {
+					pw.CloseWithError(err)
+					return
+				}
+			}
+			header := textproto.MIMEHeader{}
+			header.Set("Content-Disposition", fmt.Sprintf(`form-data; name=%q; filename=%q`, field, filepath.Base(filename)))
+			header.Set("Content-Type", cmp.Or(mime.TypeByExtension(filepath.Ext(filename)), "application/octet-stream"))
+			part, err := form.CreatePart(header)
+			if err == nil {
+				_, err = io.Copy(part, file)
+			}
+			if err == nil {
+				err = form.Close()
+			}
+			pw.CloseWithError(err)
+		}()
+		return pr, form.FormDataContentType(), nil
+	}
+}
+
+// fileBody sends a file as the whole body, typed by its extension
+func fileBody(filename string) func() (io.Reader, string, error) {
+	return func() (io.Reader, string, error) {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			return nil, "", err
+		}
+		contentType := "application/json"
+		if strings.EqualFold(filepath.Ext(filename), ".csv") {
+			contentType = "text/csv"
+		}
+		return bytes.NewReader(data), contentType, nil
+	}
+}
+
+// writeCLIResponse prints an envelope as a table or JSON, or copies any other response to outFile
+// or standard output
+func writeCLIResponse(w io.Writer, resp *http.Response, format string, columns []string, outFile string) error {
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if resp.StatusCode < 300 && mediaType != "application/json" {
+		defer resp.Body.Close()
+		if outFile == "" {
+			_, err := io.Copy(w, resp.Body)
+			return err
+		}
+		file, err := os.Create(outFile)
+		if err != nil {
+			return err
+		}
+		n, err := io.Copy(file, resp.Body)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			fmt.Fprintf(os.Stderr, "Wrote %d bytes to %s\n", n, outFile)
+		}
+		return err
+	}
+
+	envelope, err := decodeCLIEnvelope(resp, nil)
+	if err != nil {
+		return err
+	}
+	if format == cliOutputJSON {
+		data, _ := json.MarshalIndent(envelope, "", "  ")
+		_, err := fmt.Fprintf(w, "%s\n", data)
+		return err
+	}
+	if err := renderCLITable(w, envelope.Data, columns); err != nil {
+		return err
+	}
+	if envelope.TxID != "" {
+		fmt.Fprintf(w, "tx_id: %s", envelope.TxID)
+		if envelope.BlockNumber != nil {
+			fmt.Fprintf(w, " (block %d)", *envelope.BlockNumber)
+		}
+		fmt.Fprintln(w)
+	}
+	return nil
+}
+
+// renderCLITable prints a list of records as rows, and a single record as key and value lines. A
+// record holding one list, such as a page of incidents with its bookmark, is printed as the list
+// followed by its other fields.
+func renderCLITable(w io.Writer, data json.RawMessage, columns []string) error {
+	if len(data) == 0 {
+		return nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	defer tw.Flush()
+
+	switch v := value.(type) {
+	case []interface{}:
+		writeCLIRows(tw, v, columns)
+	case map[string]interface{}:
+		listKey := ""
+		for key, item := range v {
+			if rows, ok := item.([]interface{}); ok && isRecordList(rows) {
+				if listKey != "" {
+					listKey = ""
+					break
+				}
+				listKey = key
+			}
+		}
+		if listKey == "" {
+			writeCLIRecord(tw, v, columns)
+			return nil
+		}
+		writeCLIRows(tw, v[listKey].([]interface{}), columns)
+		tw.Flush()
+		rest := map[string]interface{}{}
+		for key, item := range v {
+			if key != listKey && item != nil && item != "" {
+				rest[key] = item
+			}
+		}
+		if len(rest) > 0 {
+			fmt.Fprintln(tw)
+			writeCLIRecord(tw, rest, nil)
+		}
+	default:
+		fmt.Fprintln(tw, formatCLICell(v))
+	}
+	return nil
+}
+
+func isRecordList(rows []interface{}) bool {
+	for _, row := range rows {
+		if _, ok := row.(map[string]interface{}); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func writeCLIRows(w io.Writer, rows []interface{}, columns []string) {
+	if len(rows) == 0 {
+		fmt.Fprintln(w, "No results")
+		return
+	}
+	if len(columns) == 0 {
+		seen := map[string]bool{}
+		for _, row := range rows {
+			for key := range row.(map[string]interface{}) {
+				seen[key] = true
+			}
+		}
+		for key := range seen {
+			columns = append(columns, key)
+		}
+		slices.Sort(columns)
+	}
+	fmt.Fprintln(w, strings.ToUpper(strings.Join(columns, "\t")))
+	for _, row := range rows {
+		record, _ := row.(map[string]interface{})
+		cells := make([]string, len(columns))
+		for i, column := range columns {
+			cells[i] = formatCLICell(record[column])
+		}
+		fmt.Fprintln(w, strings.Join(cells, "\t"))
+	}
+}
+
+func writeCLIRecord(w io.Writer, record map[string]interface{}, keys []string) {
+	if len(keys) == 0 {
+		for key := range record {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+	}
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s:\t%s\n", key, formatCLICell(record[key]))
+	}
+}
+
+func formatCLICell(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "-"
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	data, _ := json.Marshal(value)
+	if len(data) > maxCLICellLength {
+		return string(data[:maxCLICellLength-1]) + "…"
+	}
+	return string(data)
+}
+
+// newAPICommand calls any route, for the ones without a command of their own
+func newAPICommand(opts *cliOptions) *cobra.Command {
+	var data, outFile string
+	var query []string
+	cmd := &cobra.Command{
+		Use:   "api <method> <path>",
+		Short: "Call any gateway route, such as 'api GET /geofence/zones'",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := opts.client()
+			if err != nil {
+				return err
+			}
+			format, err := opts.format(client.profile)
+			if err != nil {
+				return err
+			}
+			req := cliRequest{method: strings.ToUpper(args[0]), path: args[1], query: url.Values{}}
+			for _, pair := range query {
+				key, value, _ := strings.Cut(pair, "=")
+				req.query.Add(key, value)
+			}
+			if data != "" {
+				raw := []byte(data)
+				if filename, ok := strings.CutPrefix(data, "@"); ok {
+					if raw, err = os.ReadFile(filename); err != nil {
+						return err
+					}
+				}
+				req.body = func() (io.Reader, string, error) { return bytes.NewReader(raw), "application/json", nil }
+			}
+			ctx, cancel := context.WithTimeout(cmd.Context(), opts.timeout)
+			defer cancel()
+			resp, err := client.do(ctx, req)
+			if err != nil {
+				return err
+			}
+			return writeCLIResponse(opts.out, resp, format, opts.columns, outFile)
+		},
+	}
+	cmd.Flags().StringVarP(&data, "data", "d", "", "JSON body, or @file to read it from a file")
+	cmd.Flags().StringArrayVarP(&query, "query", "q", nil, "query parameter as key=value; repeatable")
+	cmd.Flags().StringVar(&outFile, "out", "", "write a file response here instead of standard output")
+	return cmd
+}
+
+func newProfileCommand(opts *cliOptions) *cobra.Command {
+	cmd := &cobra.Command{Use: "profile", Short: "Manage the identities sih-cli acts as"}
+
+	set := &cobra.Command{
+		Use:   "set <name>",
+		Short: "Create or change a profile; only the given flags change",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config, err := loadCLIConfig(opts.configFile)
+			if err != nil {
+				return err
+			}
+			profile := config.Profiles[args[0]]
+			if profile == nil {
+				profile = &cliProfile{}
+				config.Profiles[args[0]] = profile
+			}
+			flags := cmd.Flags()
+			for flag, field := range map[string]*string{
+				"url": &profile.URL, "api-key": &profile.APIKey, "api-key-env": &profile.APIKeyEnv,
+				"client-cert": &profile.ClientCert, "client-key": &profile.ClientKey, "ca-cert": &profile.CACert,
+				"actor": &profile.Actor, "language": &profile.Language, "output": &profile.Output,
+			} {
+				if flags.Changed(flag) {
+					*field, _ = flags.GetString(flag)
+				}
+			}
+			if profile.URL == "" {
+				return fmt.Errorf("profile %s needs a --url", args[0])
+			}
+			if profile.Actor != "" && !identifierRegex.MatchString(profile.Actor) {
+				return fmt.Errorf("actor %q is not a valid identifier", profile.Actor)
+			}
+			if config.Current == "" {
+				config.Current = args[0]
+			}
+			return config.save()
+		},
+	}
+	set.Flags().String("url", "", "gateway URL, such as https://gateway:8080")
+	set.Flags().String("api-key", "", "API key; prefer --api-key-env")
+	set.Flags().String("api-key-env", "", "environment variable holding the API key")
+	set.Flags().String("client-cert", "", "client certificate for mutual TLS")
+	set.Flags().String("client-key", "", "client certificate's key")
+	set.Flags().String("ca-cert", "", "CA certificate the gateway's TLS certificate chains to")
+	set.Flags().String("actor", "", "default actor for write commands")
+	set.Flags().String("language", "", "language for error messages, such as hi")
+	set.Flags().String("output", "", "default output, table or json")
+
+	use := &cobra.Command{
+		Use:   "use <name>",
+		Short: "Make a profile the current one",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config, err := loadCLIConfig(opts.configFile)
+			if err != nil {
+				return err
+			}
+			if _, ok := config.Profiles[args[0]]; !ok {
+				return fmt.Errorf("profile %q is not in %s", args[0], config.path)
+			}
+			config.Current = args[0]
+			return config.save()
+		},
+	}
+
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List profiles",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config, err := loadCLIConfig(opts.configFile)
+			if err != nil {
+				return err
+			}
+			names := make([]string, 0, len(config.Profiles))
+			for name := range config.Profiles {
+				names = append(names, name)
+			}
+			slices.Sort(names)
+			tw := tabwriter.NewWriter(opts.out, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(tw, "\tNAME\tURL\tACTOR\tAUTH")
+			for _, name := range names {
+				p := config.Profiles[name]
+				current := ""
+				if name == config.Current {
+					current = "*"
+				}
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", current, name, p.URL, cmp.Or(p.Actor, "-"), p.auth())
+			}
+			return tw.Flush()
+		},
+	}
+
+	remove := &cobra.Command{
+		Use:   "delete <name>",
+		Short: "Delete a profile",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config, err := loadCLIConfig(opts.configFile)
+			if err != nil {
+				return err
+			}
+			if _, ok := config.Profiles[args[0]]; !ok {
+				return fmt.Errorf("profile %q is not in %s", args[0], config.path)
+			}
+			delete(config.Profiles, args[0])
+			if config.Current == args[0] {
+				config.Current = ""
+			}
+			return config.save()
+		},
+	}
+
+	cmd.AddCommand(set, use, list, remove)
+	return cmd
+}
+
+// auth describes how a profile authenticates, without revealing its credentials
+func (p *cliProfile) auth() string {
+	var methods []string
+	if p.Session != nil {
+		methods = append(methods, "session")
+	}
+	switch {
+	case p.APIKeyEnv != "":
+		methods = append(methods, "key $"+p.APIKeyEnv)
+	case p.APIKey != "":
+		methods = append(methods, "key")
+	}
+	if p.ClientCert != "" {
+		methods = append(methods, "mTLS")
+	}
+	if len(methods) == 0 {
+		return "none"
+	}
+	return strings.Join(methods, ", ")
+}
+
+// newLoginCommand trades the profile's API key for a session, so the key need not stay on a shared
+// device
+func newLoginCommand(opts *cliOptions) *cobra.Command {
+	var deviceID string
+	cmd := &cobra.Command{
+		Use:   "login",
+		Short: "Open a session with the profile's API key",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := opts.client()
+			if err != nil {
+				return err
+			}
+			if client.profile.apiKey() == "" {
+				return fmt.Errorf("profile %s has no API key to log in with", client.name)
+			}
+			client.profile.Session = nil
+			body, _ := json.Marshal(IssueTokenRequest{DeviceID: deviceID})
+			resp, err := client.send(cmd.Context(), cliRequest{method: http.MethodPost, path: "/auth/token",
+				body: func() (io.Reader, string, error) { return bytes.NewReader(body), "application/json", nil }})
+			if err != nil {
+				return err
+			}
+			var tokens TokenResponse
+			if _, err := decodeCLIEnvelope(resp, &tokens); err != nil {
+				return err
+			}
+			client.profile.Session = sessionFromTokens(tokens)
+			if err := client.config.save(); err != nil {
+				return err
+			}
+			fmt.Fprintf(opts.out, "Logged in as %s until %s\n", client.name, tokens.RefreshExpiresAt)
+			return nil
+		},
+	}
+	hostname, _ := os.Hostname()
+	cmd.Flags().StringVar(&deviceID, "device", strings.Split(hostname, ".")[0], "device name shown in the session list")
+	return cmd
+}
+
+func newLogoutCommand(opts *cliOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "logout",
+		Short: "End the profile's session",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := opts.client()
+			if err != nil {
+				return err
+			}
+			if client.profile.Session == nil {
+				return fmt.Errorf("profile %s has no session", client.name)
+			}
+			resp, err := client.send(cmd.Context(), cliRequest{method: http.MethodPost, path: "/auth/logout"})
+			if err != nil {
+				return err
+			}
+			// A session the gateway already ended is gone either way
+			if resp.StatusCode == http.StatusUnauthorized {
+				resp.Body.Close()
+			} else if _, err := decodeCLIEnvelope(resp, nil); err != nil {
+				return err
+			}
+			client.profile.Session = nil
+			return client.config.save()
+		},
+	}
+}
@@ -0,0 +1,211 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func runCLICommand(t *testing.T, config string, args ...string) (string, error) {
+	t.Helper()
+	var out bytes.Buffer
+	cmd := newCLICommand(&out)
+	cmd.SetErr(io.Discard)
+	cmd.SetArgs(append([]string{"--config", config}, args...))
+	err := cmd.Execute()
+	return out.String(), err
+}
+
+func TestCLIProfiles(t *testing.T) {
+	config := filepath.Join(t.TempDir(), "sih", "cli.json")
+	if _, err := runCLICommand(t, config, "profile", "set", "officer", "--url", "https://gateway:8080", "--api-key", "secret", "--actor", "officer_ravi"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runCLICommand(t, config, "profile", "set", "admin", "--url", "https://gateway:8080", "--api-key-env", "ADMIN_KEY", "--client-cert", "admin.pem"); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(config); err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("expected the profiles kept private, got %v, %v", info, err)
+	}
+	if _, err := runCLICommand(t, config, "profile", "set", "bad", "--actor", "x"); err == nil {
+		t.Error("expected a profile without a URL to be refused")
+	}
+
+	out, err := runCLICommand(t, config, "profile", "list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "*  officer  https://gateway:8080  officer_ravi  key") || !strings.Contains(out, "key $ADMIN_KEY, mTLS") || strings.Contains(out, "secret") {
+		t.Errorf("expected the first profile current and no keys shown, got\n%s", out)
+	}
+
+	runCLICommand(t, config, "profile", "use", "admin")
+	loaded, _ := loadCLIConfig(config)
+	t.Setenv(cliProfileEnv, "")
+	if name, _, err := loaded.profile(""); err != nil || name != "admin" {
+		t.Errorf("expected admin to be current, got %s, %v", name, err)
+	}
+	t.Setenv(cliProfileEnv, "officer")
+	if name, p, _ := loaded.profile(""); name != "officer" || p.apiKey() != "secret" {
+		t.Errorf("expected SIH_PROFILE to pick the officer, got %s", name)
+	}
+	if _, _, err := loaded.profile("nobody"); err == nil {
+		t.Error("expected an unknown profile to be refused")
+	}
+}
+
+func TestCLIEndpointCommands(t *testing.T) {
+	var got struct {
+		path, query, key, idempotency string
+		body                          map[string]interface{}
+		form                          []string
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got.path, got.query, got.key, got.idempotency = r.URL.Path, r.URL.RawQuery, r.Header.Get(apiKeyHeader), r.Header.Get(idempotencyHeader)
+		got.body, got.form = nil, nil
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api/v1/incident/":
+			json.NewDecoder(r.Body).Decode(&got.body)
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"success":true,"data":{"incident_id":"INC-1","status":"open"},"tx_id":"tx9","block_number":5}`))
+		case "/api/v1/incident":
+			w.Write([]byte(`{"success":true,"data":{"incidents":[{"incident_id":"INC-1","status":"open","severity":"high","reporter":"officer_ravi","geohash":"tuvz4"}],"bookmark":"b2","count":1}}`))
+		case "/api/v1/evidence/upload":
+			reader, _ := r.MultipartReader()
+			for part, err := reader.NextPart(); err == nil; part, err = reader.NextPart() {
+				value, _ := io.ReadAll(part)
+				got.form = append(got.form, part.FormName()+"="+string(value))
+			}
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"success":true,"data":{"evidence_id":"EV-1"}}`))
+		case "/api/v1/did/did:sih:x":
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"success":false,"error":{"code":"VALIDATION_ERROR","message":"request validation failed","fields":[{"field":"actor","message":"is required"}]}}`))
+		case "/health":
+			w.Write([]byte(`{"success":true,"data":{"status":"healthy"}}`))
+		case "/api/v1/did/did:sih:x/qr":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte("\x89PNG"))
+		}
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	config := filepath.Join(dir, "cli.json")
+	t.Setenv("OFFICER_KEY", "key-1")
+	runCLICommand(t, config, "profile", "set", "officer", "--url", server.URL, "--api-key-env", "OFFICER_KEY", "--actor", "officer_ravi")
+	summary := filepath.Join(dir, "summary.txt")
+	os.WriteFile(summary, []byte("Tourist reported missing"), 0o600)
+
+	out, err := runCLICommand(t, config, "incident", "create", "--id", "INC-1", "--summary-file", summary, "--severity", "high", "--lat", "25.5", "--lng", "91.9", "-d", `{"category":"theft","severity":"low"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantHash, _ := fileSHA256(summary)
+	if got.body["incidentSummaryHash"] != wantHash || got.body["reporter"] != "officer_ravi" || got.body["severity"] != "high" || got.body["category"] != "theft" || got.body["latitude"] != 25.5 {
+		t.Errorf("expected the hashed summary, the profile's actor and flags over --data, got %v", got.body)
+	}
+	if got.key != "key-1" || len(got.idempotency) != 32 {
+		t.Errorf("expected the profile's key and an idempotency key, got %q and %q", got.key, got.idempotency)
+	}
+	if !strings.Contains(out, "incident_id:  INC-1") || !strings.Contains(out, "tx_id: tx9 (block 5)") {
+		t.Errorf("expected the record and its transaction, got\n%s", out)
+	}
+
+	out, err = runCLICommand(t, config, "incident", "search", "--status", "open", "--page-size", "10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.query != "page_size=10&status=open" || got.idempotency != "" {
+		t.Errorf("unexpected search request %q", got.query)
+	}
+	lines := strings.Split(out, "\n")
+	if !strings.HasPrefix(lines[0], "INCIDENT_ID  STATUS  SEVERITY  CATEGORY  REPORTER") || !strings.HasPrefix(lines[1], "INC-1        open    high      -") || strings.Contains(out, "tuvz4") || !strings.Contains(out, "bookmark:  b2") {
+		t.Errorf("expected the default columns and the bookmark, got\n%s", out)
+	}
+	if out, _ := runCLICommand(t, config, "incident", "search", "-o", "json"); !strings.Contains(out, `"bookmark": "b2"`) {
+		t.Errorf("expected the envelope as JSON, got\n%s", out)
+	}
+
+	photo := filepath.Join(dir, "photo.jpg")
+	os.WriteFile(photo, []byte("\xff\xd8\xff"), 0o600)
+	if _, err := runCLICommand(t, config, "evidence", "upload", "--id", "EV-1", "--incident", "INC-1", "--file", photo); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(got.form, ",") != "evidenceID=EV-1,incidentID=INC-1,uploadedBy=officer_ravi,file=\xff\xd8\xff" {
+		t.Errorf("expected the fields before the file, got %q", got.form)
+	}
+
+	_, err = runCLICommand(t, config, "did", "delete", "did:sih:x")
+	if err == nil || err.Error() != "400 VALIDATION_ERROR: request validation failed\n  actor: is required" {
+		t.Errorf("expected the gateway's validation error, got %v", err)
+	}
+	if out, err := runCLICommand(t, config, "stats", "health"); err != nil || !strings.Contains(out, "status:  healthy") {
+		t.Errorf("expected /health outside /api/v1, got %q, %v", out, err)
+	}
+	qr := filepath.Join(dir, "qr.png")
+	if _, err := runCLICommand(t, config, "did", "qr", "did:sih:x", "--out", qr); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(qr); string(data) != "\x89PNG" {
+		t.Errorf("expected the image written to --out, got %q", data)
+	}
+	if _, err := runCLICommand(t, config, "incident", "get"); err == nil {
+		t.Error("expected a missing ID to be refused")
+	}
+}
+
+func TestCLISessionRefresh(t *testing.T) {
+	var idempotency []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/api/v1/auth/token" && r.Header.Get(apiKeyHeader) == "key-1":
+			w.Write([]byte(`{"success":true,"data":{"access_token":"old","refresh_token":"r1","refresh_expires_at":"2026-10-16T20:00:00Z","session_id":"s1"}}`))
+		case r.URL.Path == "/api/v1/auth/refresh":
+			var req RefreshTokenRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			if req.RefreshToken != "r1" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(`{"success":true,"data":{"access_token":"new","refresh_token":"r2","refresh_expires_at":"2026-10-16T20:00:00Z","session_id":"s1"}}`))
+		case r.Header.Get("Authorization") == "Bearer old":
+			idempotency = append(idempotency, r.Header.Get(idempotencyHeader))
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"success":false,"error":{"code":"TOKEN_EXPIRED","message":"expired"}}`))
+		case r.Header.Get("Authorization") == "Bearer new":
+			idempotency = append(idempotency, r.Header.Get(idempotencyHeader))
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"success":true,"data":{"alert_id":"SOS-1"},"tx_id":"tx1","block_number":2}`))
+		default:
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer server.Close()
+
+	config := filepath.Join(t.TempDir(), "cli.json")
+	runCLICommand(t, config, "profile", "set", "officer", "--url", server.URL, "--api-key", "key-1")
+	if out, err := runCLICommand(t, config, "login", "--device", "tablet-7"); err != nil || !strings.Contains(out, "Logged in as officer") {
+		t.Fatalf("expected a session, got %q, %v", out, err)
+	}
+
+	out, err := runCLICommand(t, config, "sos", "raise", "--digital-id", "did:sih:t1", "--lat", "25.5", "--lng", "91.9")
+	if err != nil || !strings.Contains(out, "SOS-1") {
+		t.Fatalf("expected the alert after a refresh, got %q, %v", out, err)
+	}
+	if len(idempotency) != 2 || idempotency[0] != idempotency[1] {
+		t.Errorf("expected the retry to reuse the idempotency key, got %v", idempotency)
+	}
+	loaded, _ := loadCLIConfig(config)
+	if s := loaded.Profiles["officer"].Session; s == nil || s.AccessToken != "new" || s.RefreshToken != "r2" {
+		t.Errorf("expected the rotated tokens saved, got %+v", s)
+	}
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// clientCertRule maps verified client certificates to a caller. Every match field that is set
+// must agree with the certificate.
+type clientCertRule struct {
+	// Name is the caller's RBAC subject, "cert:<name>"
+	Name               string `json:"name"`
+	CommonName         string `json:"common_name,omitempty"`
+	OrganizationalUnit string `json:"organizational_unit,omitempty"`
+	// Fingerprint is the hex SHA-256 of the certificate's DER encoding
+	Fingerprint string `json:"fingerprint,omitempty"`
+	// Wallet is the identity the caller signs as, which also sets its organization
+	Wallet string `json:"wallet,omitempty"`
+}
+
+// clientCertCaller is the mapped caller of a request that authenticated with its certificate
+type clientCertCaller struct {
+	Name string
+}
+
+type clientCertContextKey struct{}
+
+// clientCertRules holds the rules from TLS_CLIENT_IDENTITIES_FILE, replaced on every TLS reload
+var clientCertRules atomic.Pointer[[]clientCertRule]
+
+// readClientCertRules parses the rules file, checking that each rule can match and that its wallet
+// identity is loaded
+func readClientCertRules(path string) ([]clientCertRule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client identities: %w", err)
+	}
+	var rules []clientCertRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("failed to parse client identities %s: %w", path, err)
+	}
+	for i, rule := range rules {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("client identity %d has no name", i+1)
+		}
+		if rule.CommonName == "" && rule.OrganizationalUnit == "" && rule.Fingerprint == "" {
+			return nil, fmt.Errorf("client identity %s matches every certificate; set common_name, organizational_unit or fingerprint", rule.Name)
+		}
+		if rule.Fingerprint != "" {
+			fingerprint, err := hex.DecodeString(strings.ReplaceAll(rule.Fingerprint, ":", ""))
+			if err != nil || len(fingerprint) != sha256.Size {
+				return nil, fmt.Errorf("client identity %s: fingerprint must be a hex SHA-256", rule.Name)
+			}
+			rules[i].Fingerprint = hex.EncodeToString(fingerprint)
+		}
+		if rule.Wallet != "" && (callerWallet == nil || callerWallet.identity(rule.Wallet) == nil) {
+			return nil, fmt.Errorf("client identity %s maps to unknown wallet identity %q", rule.Name, rule.Wallet)
+		}
+	}
+	return rules, nil
+}
+
+// matchClientCert returns the first rule matching a verified client certificate, or nil
+func matchClientCert(cert *x509.Certificate) *clientCertRule {
+	rules := clientCertRules.Load()
+	if rules == nil || cert == nil {
+		return nil
+	}
+	sum := sha256.Sum256(cert.Raw)
+	fingerprint := hex.EncodeToString(sum[:])
+	for _, rule := range *rules {
+		if rule.CommonName != "" && rule.CommonName != cert.Subject.CommonName {
+			continue
+		}
+		if rule.OrganizationalUnit != "" && !slices.Contains(cert.Subject.OrganizationalUnit, rule.OrganizationalUnit) {
+			continue
+		}
+		if rule.Fingerprint != "" && rule.Fingerprint != fingerprint {
+			continue
+		}
+		return &rule
+	}
+	return nil
+}
+
+// withClientCert records the mapped caller and signs as its wallet identity
+func withClientCert(ctx context.Context, rule *clientCertRule) context.Context {
+	ctx = context.WithValue(ctx, clientCertContextKey{}, &clientCertCaller{Name: rule.Name})
+	if rule.Wallet != "" {
+		ctx = withSigner(ctx, callerWallet.identity(rule.Wallet))
+	}
+	return ctx
+}
+
+// clientCertFromContext returns the caller mapped from the request's client certificate
+func clientCertFromContext(ctx context.Context) *clientCertCaller {
+	caller, _ := ctx.Value(clientCertContextKey{}).(*clientCertCaller)
+	return caller
+}
+
+// verifiedClientCert returns the leaf of the first verified chain, ignoring certificates that
+// were presented but not checked against the client CA
+func verifiedClientCert(chains [][]*x509.Certificate) *x509.Certificate {
+	if len(chains) == 0 || len(chains[0]) == 0 {
+		return nil
+	}
+	return chains[0][0]
+}
+
+// grpcClientCert returns the verified client certificate of an RPC
+func grpcClientCert(ctx context.Context) *x509.Certificate {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return nil
+	}
+	return verifiedClientCert(info.State.VerifiedChains)
+}
@@ -0,0 +1,106 @@
+package gateway
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func clientCertificate(t *testing.T, cn string, ou ...string) *x509.Certificate {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn, OrganizationalUnit: ou},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return cert
+}
+
+func TestClientCertMapping(t *testing.T) {
+	previous := callerWallet
+	callerWallet = &wallet{identities: map[string]*walletIdentity{"control-room": {Label: "control-room", MSPID: "Org2MSP"}}, callers: map[string]string{}, requireCaller: true}
+	defer func() { callerWallet = previous; clientCertRules.Store(nil) }()
+
+	pinned := clientCertificate(t, "kiosk-3")
+	sum := sha256.Sum256(pinned.Raw)
+	path := filepath.Join(t.TempDir(), "clients.json")
+	write := func(rules string) {
+		if err := os.WriteFile(path, []byte(rules), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(`[{"name": "everyone", "wallet": "control-room"}]`)
+	if _, err := readClientCertRules(path); err == nil {
+		t.Error("expected a rule without match fields rejected")
+	}
+	write(`[{"name": "desk", "common_name": "desk-1", "wallet": "officer-9"}]`)
+	if _, err := readClientCertRules(path); err == nil {
+		t.Error("expected a rule for an unknown wallet identity rejected")
+	}
+	write(`[
+		{"name": "shillong-desk", "common_name": "desk-1", "organizational_unit": "shillong", "wallet": "control-room"},
+		{"name": "kiosk", "fingerprint": "` + strings.ToUpper(hex.EncodeToString(sum[:])) + `"}
+	]`)
+	rules, err := readClientCertRules(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCertRules.Store(&rules)
+
+	if rule := matchClientCert(clientCertificate(t, "desk-1", "tura")); rule != nil {
+		t.Errorf("expected a certificate from another unit unmatched, got %+v", rule)
+	}
+	if rule := matchClientCert(pinned); rule == nil || rule.Name != "kiosk" {
+		t.Errorf("expected the pinned certificate matched by fingerprint, got %+v", rule)
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/", signerMiddleware(), func(c *gin.Context) {
+		ctx := c.Request.Context()
+		subjects, org := callerSubjects(ctx)
+		respondData(c, http.StatusOK, gin.H{"subjects": subjects, "org": org, "actor": apiActor(ctx, "", "", c.ClientIP())})
+	})
+	send := func(cert *x509.Certificate) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if cert != nil {
+			req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send(clientCertificate(t, "desk-1", "shillong"))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"cert:shillong-desk"`) || !strings.Contains(w.Body.String(), `"org":"Org2MSP"`) || !strings.Contains(w.Body.String(), `"actor":"wallet:control-room"`) {
+		t.Errorf("expected the desk to act as control-room with its certificate subject, got %d: %s", w.Code, w.Body)
+	}
+	if w := send(pinned); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"actor":"cert:kiosk"`) {
+		t.Errorf("expected the kiosk identified by its certificate, got %d: %s", w.Code, w.Body)
+	}
+	if w := send(clientCertificate(t, "stranger")); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected an unmapped certificate to need an API key, got %d", w.Code)
+	}
+}
@@ -0,0 +1,14 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// sih-app is the gateway
+package main
+
+import gateway "assetTransfer"
+
+func main() {
+	gateway.RunGateway()
+}
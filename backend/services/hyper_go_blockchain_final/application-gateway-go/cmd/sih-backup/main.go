@@ -0,0 +1,14 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// sih-backup is the ledger and index backup tool
+package main
+
+import gateway "assetTransfer"
+
+func main() {
+	gateway.RunBackup()
+}
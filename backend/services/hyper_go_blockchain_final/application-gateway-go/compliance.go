@@ -0,0 +1,645 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+)
+
+// Audit compliance reports. The audit entries of a target or actor over a period are checked
+// against the blocks that recorded them: each entry's transaction must be on the channel, valid and
+// signed by the organization the entry names, and each of those blocks must hash to the data it
+// holds and link to the block before it. The entries are summarized by period and action, and
+// patterns a reviewer should look at, such as deletions outside working hours, are flagged.
+
+const (
+	complianceReportType      = "sih-compliance+jwt"
+	complianceSignatureHeader = "X-Compliance-Signature"
+	// maxComplianceEntries bounds the entries one report checks; longer periods are split
+	maxComplianceEntries  = 5000
+	complianceConcurrency = 8
+)
+
+// Compliance findings. High severity findings mean the audit trail cannot be trusted as it stands;
+// the others are for a reviewer to look at.
+const (
+	findingMissingTransaction = "missing_transaction"
+	findingInvalidTransaction = "invalid_transaction"
+	findingSubmitterMismatch  = "submitter_mismatch"
+	findingBrokenChain        = "broken_chain"
+	findingTimestampSkew      = "timestamp_skew"
+	findingOffHoursDelete     = "off_hours_delete"
+	findingActivityGap        = "activity_gap"
+
+	severityHigh   = "high"
+	severityMedium = "medium"
+	severityLow    = "low"
+)
+
+var (
+	complianceFormats = []string{"json", "pdf"}
+	compliancePeriods = []string{"day", "week", "month"}
+
+	findingSeverities = map[string]string{
+		findingMissingTransaction: severityHigh,
+		findingInvalidTransaction: severityHigh,
+		findingSubmitterMismatch:  severityHigh,
+		findingBrokenChain:        severityHigh,
+		findingTimestampSkew:      severityMedium,
+		findingOffHoursDelete:     severityMedium,
+		findingActivityGap:        severityLow,
+	}
+)
+
+// ComplianceRequest asks for the report on one target or one actor over a period
+type ComplianceRequest struct {
+	TargetID string `form:"targetId"`
+	Actor    string `form:"actor"`
+	From     string `form:"from" binding:"required"`
+	To       string `form:"to" binding:"required"`
+	Period   string `form:"period"`
+	Format   string `form:"format"`
+}
+
+func (r ComplianceRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	switch {
+	case (r.TargetID == "") == (r.Actor == ""):
+		v.add("targetId", "give either targetId or actor")
+	case r.TargetID != "":
+		v.errors = append(v.errors, validateDocumentID("targetId", r.TargetID)...)
+	default:
+		v.identifier("actor", r.Actor)
+	}
+	v.timeRange(r.From, r.To)
+	if r.Period != "" {
+		v.oneOf("period", r.Period, compliancePeriods)
+	}
+	if r.Format != "" {
+		v.oneOf("format", r.Format, complianceFormats)
+	}
+	return v.errors
+}
+
+// ComplianceFinding is one flagged audit entry, or a flagged block for a broken chain
+type ComplianceFinding struct {
+	Kind        string `json:"kind"`
+	Severity    string `json:"severity"`
+	Timestamp   string `json:"timestamp,omitempty"`
+	Actor       string `json:"actor,omitempty"`
+	Action      string `json:"action,omitempty"`
+	TargetID    string `json:"target_id,omitempty"`
+	TxID        string `json:"tx_id,omitempty"`
+	BlockNumber uint64 `json:"block_number,omitempty"`
+	Detail      string `json:"detail"`
+}
+
+// CompliancePeriod counts the entries of one day, week or month by action
+type CompliancePeriod struct {
+	Start   string         `json:"start"`
+	Entries int            `json:"entries"`
+	Actions map[string]int `json:"actions"`
+}
+
+// ComplianceSummary counts what was checked. Verified entries have no high severity finding, and
+// the trail is compliant when every entry is verified.
+type ComplianceSummary struct {
+	Entries      int            `json:"entries"`
+	Verified     int            `json:"verified"`
+	Transactions int            `json:"transactions"`
+	Blocks       int            `json:"blocks"`
+	Findings     map[string]int `json:"findings"`
+	Compliant    bool           `json:"compliant"`
+}
+
+// ComplianceReport is the signed outcome of checking a target's or actor's audit trail
+type ComplianceReport struct {
+	ReportID     string              `json:"report_id"`
+	Issuer       string              `json:"issuer"`
+	Channel      string              `json:"channel"`
+	TargetID     string              `json:"target_id,omitempty"`
+	Actor        string              `json:"actor,omitempty"`
+	From         string              `json:"from"`
+	To           string              `json:"to"`
+	Period       string              `json:"period"`
+	Timezone     string              `json:"timezone"`
+	WorkingHours string              `json:"working_hours"`
+	GeneratedAt  string              `json:"generated_at"`
+	Summary      ComplianceSummary   `json:"summary"`
+	ByAction     map[string]int      `json:"by_action"`
+	BySubmitter  map[string]int      `json:"by_submitter"`
+	Periods      []CompliancePeriod  `json:"periods"`
+	Findings     []ComplianceFinding `json:"findings"`
+	SigningKey   gin.H               `json:"signing_key"`
+}
+
+// SignedComplianceReport carries the report and its compact JWS
+type SignedComplianceReport struct {
+	Report    *ComplianceReport `json:"report"`
+	Signature string            `json:"signature"`
+}
+
+// compliancePDFClaims are what the JWS of a PDF report signs: the PDF's digest and the verdict
+type compliancePDFClaims struct {
+	ReportID    string            `json:"report_id"`
+	Issuer      string            `json:"issuer"`
+	GeneratedAt string            `json:"generated_at"`
+	PDFSHA256   string            `json:"pdf_sha256"`
+	Summary     ComplianceSummary `json:"summary"`
+}
+
+// complianceService checks audit trails against the ledger's blocks. Lookups are functions so the
+// checks can run against recorded blocks.
+type complianceService struct {
+	signer   *qrSigner
+	issuer   string
+	location *time.Location
+	// workStart and workEnd bound working hours in minutes after local midnight
+	workStart, workEnd int
+	maxSkew            time.Duration
+	maxGap             time.Duration
+
+	tx    func(ctx context.Context, txID string) (LedgerTxInfo, error)
+	block func(ctx context.Context, number uint64) (*common.Block, error)
+}
+
+var compliance *complianceService
+
+func initCompliance() {
+	timezone := getEnv("COMPLIANCE_TIMEZONE", getEnv("REPORT_TIMEZONE", defaultZoneTimezone))
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		panic(fmt.Errorf("COMPLIANCE_TIMEZONE %q is not a known timezone", timezone))
+	}
+	hours := getEnv("COMPLIANCE_WORKING_HOURS", "08:00-20:00")
+	start, end, ok := parseWorkingHours(hours)
+	if !ok {
+		panic(fmt.Errorf("COMPLIANCE_WORKING_HOURS %q must be HH:MM-HH:MM", hours))
+	}
+	s := &complianceService{
+		issuer:    getEnv("COMPLIANCE_ISSUER", "did:sih:gateway"),
+		location:  location,
+		workStart: start,
+		workEnd:   end,
+		maxSkew:   getEnvDuration("COMPLIANCE_MAX_SKEW", 5*time.Minute),
+		maxGap:    getEnvDuration("COMPLIANCE_MAX_GAP", 0),
+		tx:        ledger.GetLedgerTransaction,
+		block:     readBlock,
+	}
+	if s.maxSkew <= 0 || s.maxGap < 0 {
+		panic(fmt.Errorf("COMPLIANCE_MAX_SKEW must be positive and COMPLIANCE_MAX_GAP must not be negative"))
+	}
+
+	if path := getEnv("COMPLIANCE_SIGNING_KEY_FILE", ""); path != "" {
+		signer, err := loadQRSigner(path)
+		if err != nil {
+			panic(fmt.Errorf("failed to load compliance signing key: %w", err))
+		}
+		s.signer = signer
+	} else {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			panic(fmt.Errorf("failed to generate compliance signing key: %w", err))
+		}
+		s.signer = newQRSigner(key)
+		log.Printf("⚠️  COMPLIANCE_SIGNING_KEY_FILE not set; compliance reports are signed with an ephemeral key (%s)", s.signer.keyID)
+	}
+	compliance = s
+	log.Printf("📑 Compliance reports flag deletions outside %s %s", hours, timezone)
+}
+
+// parseWorkingHours reads "HH:MM-HH:MM" as minutes after midnight
+func parseWorkingHours(value string) (int, int, bool) {
+	from, to, ok := strings.Cut(value, "-")
+	if !ok {
+		return 0, 0, false
+	}
+	start, err1 := time.Parse("15:04", strings.TrimSpace(from))
+	end, err2 := time.Parse("15:04", strings.TrimSpace(to))
+	if err1 != nil || err2 != nil || !start.Before(end) {
+		return 0, 0, false
+	}
+	return start.Hour()*60 + start.Minute(), end.Hour()*60 + end.Minute(), true
+}
+
+func (s *complianceService) workingHours() string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d", s.workStart/60, s.workStart%60, s.workEnd/60, s.workEnd%60)
+}
+
+// offHours reports whether t falls outside working hours in the report's timezone
+func (s *complianceService) offHours(t time.Time) bool {
+	local := t.In(s.location)
+	minute := local.Hour()*60 + local.Minute()
+	return minute < s.workStart || minute >= s.workEnd
+}
+
+// destructiveAction reports whether an audit action removes a record
+func destructiveAction(action string) bool {
+	return strings.HasPrefix(action, "DELETE_") || action == "API_DELETE"
+}
+
+// periodStart returns the start of the day, week or month containing t. Weeks start on Monday.
+func (s *complianceService) periodStart(period string, t time.Time) time.Time {
+	switch period {
+	case "week":
+		start, _ := reportPeriodAt(reportWeekly, t, s.location)
+		return start
+	case "month":
+		local := t.In(s.location)
+		return time.Date(local.Year(), local.Month(), 1, 0, 0, 0, 0, s.location)
+	default:
+		start, _ := reportPeriodAt(reportDaily, t, s.location)
+		return start
+	}
+}
+
+// auditEntries reads the audit entries in scope, oldest first
+func (s *complianceService) auditEntries(ctx context.Context, req ComplianceRequest) ([]AuditDocument, error) {
+	from, _ := time.Parse(time.RFC3339, req.From)
+	to, _ := time.Parse(time.RFC3339, req.To)
+	var entries []AuditDocument
+	if req.TargetID != "" {
+		audits, err := ledger.ListAuditsByTarget(ctx, req.TargetID)
+		if err != nil {
+			return nil, err
+		}
+		for _, audit := range audits {
+			if inPeriod(audit.Timestamp, from, to) {
+				entries = append(entries, audit)
+			}
+		}
+	} else {
+		search := AuditSearchRequest{Actor: req.Actor, From: req.From, To: req.To, PageSize: maxPageSize}
+		for {
+			page, err := ledger.SearchAudits(ctx, search)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, page.Audits...)
+			if page.Bookmark == "" || len(entries) > maxComplianceEntries {
+				break
+			}
+			search.Bookmark = page.Bookmark
+		}
+	}
+	if len(entries) > maxComplianceEntries {
+		return nil, ValidationErrors{{Field: "from", Message: fmt.Sprintf("the period holds more than %d audit entries; narrow it", maxComplianceEntries)}}
+	}
+	slices.SortStableFunc(entries, func(a, b AuditDocument) int { return strings.Compare(a.Timestamp, b.Timestamp) })
+	return entries, nil
+}
+
+// generate checks the audit entries in scope against the ledger and summarizes them
+func (s *complianceService) generate(ctx context.Context, req ComplianceRequest) (*ComplianceReport, error) {
+	if errs := req.Validate(); len(errs) > 0 {
+		return nil, errs
+	}
+	if req.Period == "" {
+		req.Period = "day"
+	}
+	entries, err := s.auditEntries(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	report := &ComplianceReport{
+		ReportID:     "COMPLIANCE-" + now.Format("20060102T150405Z"),
+		Issuer:       s.issuer,
+		Channel:      targetFromContext(ctx).Channel,
+		TargetID:     req.TargetID,
+		Actor:        req.Actor,
+		From:         req.From,
+		To:           req.To,
+		Period:       req.Period,
+		Timezone:     s.location.String(),
+		WorkingHours: s.workingHours(),
+		GeneratedAt:  now.Format(time.RFC3339),
+	}
+	if err := s.check(ctx, entries, report); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// check fills in the report for entries, which are sorted oldest first
+func (s *complianceService) check(ctx context.Context, entries []AuditDocument, report *ComplianceReport) error {
+	txs, err := s.lookupTransactions(ctx, entries)
+	if err != nil {
+		return err
+	}
+	blocks := map[uint64]string{}
+	for _, tx := range txs {
+		if tx != nil {
+			blocks[tx.BlockNumber] = ""
+		}
+	}
+	for _, number := range slices.Sorted(maps.Keys(blocks)) {
+		detail, err := s.checkBlock(ctx, number)
+		if err != nil {
+			return err
+		}
+		blocks[number] = detail
+	}
+
+	report.Summary = ComplianceSummary{Entries: len(entries), Transactions: len(txs), Blocks: len(blocks), Findings: map[string]int{}}
+	report.ByAction, report.BySubmitter = map[string]int{}, map[string]int{}
+	report.Periods, report.Findings = []CompliancePeriod{}, []ComplianceFinding{}
+	flag := func(kind string, audit AuditDocument, detail string) {
+		report.Findings = append(report.Findings, ComplianceFinding{
+			Kind: kind, Severity: findingSeverities[kind], Timestamp: audit.Timestamp, Actor: audit.Actor,
+			Action: audit.Action, TargetID: audit.TargetID, TxID: audit.TxID, Detail: detail,
+		})
+	}
+	for _, number := range slices.Sorted(maps.Keys(blocks)) {
+		if blocks[number] != "" {
+			report.Findings = append(report.Findings, ComplianceFinding{
+				Kind: findingBrokenChain, Severity: severityHigh, BlockNumber: number, Detail: blocks[number],
+			})
+		}
+	}
+
+	var previous time.Time
+	for _, audit := range entries {
+		report.ByAction[audit.Action]++
+		report.BySubmitter[cmpOr(audit.Submitter, "unknown")]++
+		at, err := time.Parse(time.RFC3339, audit.Timestamp)
+		if err != nil {
+			flag(findingTimestampSkew, audit, "the entry has no valid timestamp")
+			continue
+		}
+		start := s.periodStart(report.Period, at).Format(time.RFC3339)
+		if n := len(report.Periods); n == 0 || report.Periods[n-1].Start != start {
+			report.Periods = append(report.Periods, CompliancePeriod{Start: start, Actions: map[string]int{}})
+		}
+		period := &report.Periods[len(report.Periods)-1]
+		period.Entries++
+		period.Actions[audit.Action]++
+
+		verified := true
+		tx := txs[audit.TxID]
+		switch {
+		case tx == nil:
+			flag(findingMissingTransaction, audit, "the transaction is not on the channel")
+			verified = false
+		case !tx.Valid:
+			flag(findingInvalidTransaction, audit, "the peers marked the transaction "+tx.ValidationCode)
+			verified = false
+		default:
+			if owner := auditOwner(audit); owner != "" && owner != tx.CreatorMSPID {
+				flag(findingSubmitterMismatch, audit, fmt.Sprintf("the entry names %s but %s signed the transaction", owner, tx.CreatorMSPID))
+				verified = false
+			}
+			if blocks[tx.BlockNumber] != "" {
+				verified = false
+			}
+			if skew, ok := s.skew(audit, at, tx); ok {
+				flag(findingTimestampSkew, audit, fmt.Sprintf("the entry is %s away from its transaction's time", skew))
+			}
+		}
+		if verified {
+			report.Summary.Verified++
+		}
+		if destructiveAction(audit.Action) && s.offHours(at) {
+			flag(findingOffHoursDelete, audit, "deleted at "+at.In(s.location).Format("15:04 Mon")+", outside working hours")
+		}
+		if s.maxGap > 0 && !previous.IsZero() && at.Sub(previous) > s.maxGap {
+			flag(findingActivityGap, audit, fmt.Sprintf("no entries for %s before this one", at.Sub(previous).Round(time.Minute)))
+		}
+		previous = at
+	}
+	for _, finding := range report.Findings {
+		report.Summary.Findings[finding.Kind]++
+	}
+	report.Summary.Compliant = report.Summary.Verified == len(entries) && report.Summary.Findings[findingBrokenChain] == 0
+	return nil
+}
+
+// skew reports how far an entry's time is from its transaction's. API entries are stamped when the
+// request arrived and recorded in a later batch, so only entries after their transaction count.
+func (s *complianceService) skew(audit AuditDocument, at time.Time, tx *LedgerTxInfo) (time.Duration, bool) {
+	committed, err := time.Parse(time.RFC3339, tx.Timestamp)
+	if err != nil {
+		return 0, false
+	}
+	skew := at.Sub(committed)
+	if strings.HasPrefix(audit.Action, "API_") && skew < 0 {
+		return 0, false
+	}
+	if skew < 0 {
+		skew = -skew
+	}
+	return skew, skew > s.maxSkew
+}
+
+// lookupTransactions looks up each entry's transaction; transactions the channel does not hold map
+// to nil
+func (s *complianceService) lookupTransactions(ctx context.Context, entries []AuditDocument) (map[string]*LedgerTxInfo, error) {
+	var txIDs []string
+	seen := map[string]bool{}
+	for _, audit := range entries {
+		if !seen[audit.TxID] {
+			seen[audit.TxID] = true
+			txIDs = append(txIDs, audit.TxID)
+		}
+	}
+	txs := make(map[string]*LedgerTxInfo, len(txIDs))
+	var mu sync.Mutex
+	var firstErr error
+	slots := make(chan struct{}, complianceConcurrency)
+	var wg sync.WaitGroup
+	for _, txID := range txIDs {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(txID string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			info, err := s.tx(ctx, txID)
+			mu.Lock()
+			defer mu.Unlock()
+			var invalid ValidationErrors
+			switch {
+			case err == nil:
+				txs[txID] = &info
+			case errors.As(err, &invalid), translateFabricError(err).Code == errCodeNotFound:
+				txs[txID] = nil
+			default:
+				txs[txID] = nil
+				if firstErr == nil {
+					firstErr = err
+				}
+			}
+		}(txID)
+	}
+	wg.Wait()
+	return txs, firstErr
+}
+
+// checkBlock checks that a block hashes to the data it holds and links to the block before it,
+// describing the break if not
+func (s *complianceService) checkBlock(ctx context.Context, number uint64) (string, error) {
+	block, err := s.block(ctx, number)
+	if err != nil {
+		return "", err
+	}
+	if !bytes.Equal(blockDataHash(block.GetData()), block.GetHeader().GetDataHash()) {
+		return "the block's transactions do not match its data hash", nil
+	}
+	if number == 0 {
+		return "", nil
+	}
+	previous, err := s.block(ctx, number-1)
+	if err != nil {
+		return "", err
+	}
+	if !bytes.Equal(blockHeaderHash(previous.GetHeader()), block.GetHeader().GetPreviousHash()) {
+		return fmt.Sprintf("the previous hash does not match block %d", number-1), nil
+	}
+	return "", nil
+}
+
+// sign signs the report as a compact JWS, naming the key as issuer#keyID. With pdf set it renders
+// the report as a PDF and signs the PDF's digest instead.
+func (s *complianceService) sign(report *ComplianceReport, pdf bool) (string, []byte, error) {
+	kid := report.Issuer + "#" + s.signer.keyID
+	report.SigningKey = s.signer.publicJWK(kid)
+	if !pdf {
+		signature, err := s.signer.signJWT(complianceReportType, kid, report)
+		return signature, nil, err
+	}
+	rendered := renderCompliancePDF(report)
+	sum := sha256.Sum256(rendered)
+	signature, err := s.signer.signJWT(complianceReportType, kid, compliancePDFClaims{
+		ReportID:    report.ReportID,
+		Issuer:      report.Issuer,
+		GeneratedAt: report.GeneratedAt,
+		PDFSHA256:   hex.EncodeToString(sum[:]),
+		Summary:     report.Summary,
+	})
+	return signature, rendered, err
+}
+
+// renderCompliancePDF lays the report out on A4 pages
+func renderCompliancePDF(r *ComplianceReport) []byte {
+	doc := newPDFDocument()
+	doc.Centered("Audit Compliance Report", 16, true)
+	scope := "Target " + r.TargetID
+	if r.Actor != "" {
+		scope = "Actor " + r.Actor
+	}
+	doc.Centered(fmt.Sprintf("%s, %s to %s", scope, r.From, r.To), 10, false)
+	doc.Space(8)
+	doc.Field("Report ID", r.ReportID, 10)
+	doc.Field("Issuer", r.Issuer, 10)
+	doc.Field("Channel", r.Channel, 10)
+	doc.Field("Generated at", r.GeneratedAt, 10)
+	doc.Field("Working hours", r.WorkingHours+" "+r.Timezone, 10)
+	if kid, ok := r.SigningKey["kid"].(string); ok {
+		doc.Field("Signing key", kid, 10)
+	}
+
+	section := func(title string) {
+		doc.Space(6)
+		doc.Rule()
+		doc.Paragraph(title, 12, true)
+	}
+	verdict := "Compliant"
+	if !r.Summary.Compliant {
+		verdict = "Not compliant"
+	}
+	section("Verification")
+	doc.Field("Verdict", verdict, 10)
+	doc.Field("Entries", strconv.Itoa(r.Summary.Entries), 10)
+	doc.Field("Verified", strconv.Itoa(r.Summary.Verified), 10)
+	doc.Field("Transactions", strconv.Itoa(r.Summary.Transactions), 10)
+	doc.Field("Blocks", strconv.Itoa(r.Summary.Blocks), 10)
+	for _, kind := range sortedKeys(r.Summary.Findings) {
+		doc.Field(kind, strconv.Itoa(r.Summary.Findings[kind]), 10)
+	}
+
+	section("Actions")
+	for _, action := range sortedKeys(r.ByAction) {
+		doc.Field(action, strconv.Itoa(r.ByAction[action]), 10)
+	}
+	doc.Paragraph("By submitter", 10, true)
+	for _, submitter := range sortedKeys(r.BySubmitter) {
+		doc.Field(submitter, strconv.Itoa(r.BySubmitter[submitter]), 10)
+	}
+
+	section("By " + r.Period)
+	for _, period := range r.Periods {
+		actions := make([]string, 0, len(period.Actions))
+		for _, action := range sortedKeys(period.Actions) {
+			actions = append(actions, fmt.Sprintf("%s %d", action, period.Actions[action]))
+		}
+		doc.Field(period.Start, fmt.Sprintf("%d: %s", period.Entries, strings.Join(actions, ", ")), 10)
+	}
+
+	section("Findings")
+	if len(r.Findings) == 0 {
+		doc.Paragraph("None", 10, false)
+	}
+	for _, finding := range r.Findings {
+		subject := finding.TxID
+		if finding.Kind == findingBrokenChain {
+			subject = fmt.Sprintf("block %d", finding.BlockNumber)
+		}
+		doc.Paragraph(fmt.Sprintf("[%s] %s %s", strings.ToUpper(finding.Severity), finding.Kind, subject), 10, true)
+		if finding.Action != "" {
+			doc.Indented(12, fmt.Sprintf("%s %s on %s by %s", finding.Timestamp, finding.Action, finding.TargetID, finding.Actor), 9, false)
+		}
+		doc.Indented(12, finding.Detail, 9, false)
+	}
+	return doc.Bytes()
+}
+
+func getComplianceReport(c *gin.Context) {
+	var req ComplianceRequest
+	if !bindQuery(c, &req) {
+		return
+	}
+	report, err := compliance.generate(c.Request.Context(), req)
+	if err != nil {
+		respondServiceError(c, "Failed to generate compliance report", err)
+		return
+	}
+	signature, pdf, err := compliance.sign(report, req.Format == "pdf")
+	if err != nil {
+		respondServiceError(c, "Failed to sign compliance report", err)
+		return
+	}
+	if pdf == nil {
+		respondData(c, http.StatusOK, SignedComplianceReport{Report: report, Signature: signature})
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.pdf"`, strings.ToLower(report.ReportID)))
+	c.Header(complianceSignatureHeader, signature)
+	c.Data(http.StatusOK, "application/pdf", pdf)
+}
+
+// getComplianceKeys publishes the key compliance reports are signed with
+func getComplianceKeys(c *gin.Context) {
+	c.Header("Cache-Control", "max-age=300")
+	c.JSON(http.StatusOK, gin.H{"keys": []gin.H{compliance.signer.publicJWK(compliance.issuer + "#" + compliance.signer.keyID)}})
+}
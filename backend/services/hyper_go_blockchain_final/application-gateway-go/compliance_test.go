@@ -0,0 +1,146 @@
+package gateway
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+)
+
+func TestComplianceCheck(t *testing.T) {
+	block := func(number uint64, previous *common.Block, txIDs ...string) *common.Block {
+		b := &common.Block{Header: &common.BlockHeader{Number: number}, Data: &common.BlockData{}}
+		for _, txID := range txIDs {
+			b.Data.Data = append(b.Data.Data, []byte(txID))
+		}
+		b.Header.DataHash = blockDataHash(b.Data)
+		if previous != nil {
+			b.Header.PreviousHash = blockHeaderHash(previous.Header)
+		}
+		return b
+	}
+	genesis := block(0, nil)
+	first := block(1, genesis, "t1", "t2", "t5", "t7")
+	second := block(2, first, "t4")
+	// A transaction rewritten after the block was cut no longer matches its data hash
+	second.Data.Data[0] = []byte("t4-rewritten")
+	blocks := []*common.Block{genesis, first, second}
+
+	at := func(clock string) string { return "2025-09-20T" + clock + "Z" }
+	txs := map[string]LedgerTxInfo{
+		"t1": {TxID: "t1", BlockNumber: 1, CreatorMSPID: "Org1MSP", Timestamp: at("05:00:00"), Valid: true},
+		"t2": {TxID: "t2", BlockNumber: 1, CreatorMSPID: "Org1MSP", Timestamp: at("19:00:00"), Valid: true},
+		"t4": {TxID: "t4", BlockNumber: 2, CreatorMSPID: "Org1MSP", Timestamp: at("06:00:00"), Valid: true},
+		"t5": {TxID: "t5", BlockNumber: 1, CreatorMSPID: "Org2MSP", Timestamp: at("07:00:00"), Valid: true},
+		"t6": {TxID: "t6", BlockNumber: 1, CreatorMSPID: "Org1MSP", Timestamp: at("08:00:00"), ValidationCode: "MVCC_READ_CONFLICT"},
+		"t7": {TxID: "t7", BlockNumber: 1, CreatorMSPID: "Org1MSP", Timestamp: at("08:00:00"), Valid: true},
+	}
+	kolkata, _ := time.LoadLocation("Asia/Kolkata")
+	s := &complianceService{
+		location: kolkata, workStart: 8 * 60, workEnd: 20 * 60, maxSkew: 5 * time.Minute, maxGap: 8 * time.Hour,
+		tx: func(_ context.Context, txID string) (LedgerTxInfo, error) {
+			if tx, ok := txs[txID]; ok {
+				return tx, nil
+			}
+			return LedgerTxInfo{}, fmt.Errorf("Failed to get transaction with id %s, error entry not found in index", txID)
+		},
+		block: func(_ context.Context, number uint64) (*common.Block, error) { return blocks[number], nil },
+	}
+
+	entry := func(txID, clock, action string) AuditDocument {
+		return AuditDocument{TxID: txID, Timestamp: at(clock), Actor: "officer_7", Action: action, TargetID: "INC-1", Submitter: "Org1MSP/officer-ravi"}
+	}
+	entries := []AuditDocument{
+		entry("t1", "05:00:00", "UPDATE_INCIDENT"),
+		entry("t3", "05:30:00", "UPDATE_INCIDENT"),
+		entry("t4", "06:00:00", "UPDATE_INCIDENT"),
+		entry("t5", "07:00:00", "UPDATE_INCIDENT"),
+		entry("t6", "08:00:00", "UPDATE_INCIDENT"),
+		entry("t7", "09:00:00", "UPDATE_INCIDENT"),
+		// 00:30 the next day in Kolkata, ten hours after the entry before
+		entry("t2", "19:00:00", "DELETE_INCIDENT"),
+	}
+	report := &ComplianceReport{Period: "day"}
+	if err := s.check(context.Background(), entries, report); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]int{
+		findingMissingTransaction: 1, findingInvalidTransaction: 1, findingSubmitterMismatch: 1, findingBrokenChain: 1,
+		findingTimestampSkew: 1, findingOffHoursDelete: 1, findingActivityGap: 1,
+	}
+	for kind, count := range want {
+		if report.Summary.Findings[kind] != count {
+			t.Errorf("expected %d %s findings, got %+v", count, kind, report.Findings)
+		}
+	}
+	// t1, t2 and t7 verify: the skewed entry and the late deletion are on the ledger as recorded
+	if s := report.Summary; s.Entries != 7 || s.Verified != 3 || s.Transactions != 7 || s.Blocks != 2 || s.Compliant {
+		t.Errorf("unexpected summary %+v", s)
+	}
+	if report.Findings[0].Kind != findingBrokenChain || report.Findings[0].BlockNumber != 2 {
+		t.Errorf("expected block 2 flagged first, got %+v", report.Findings[0])
+	}
+	if len(report.Periods) != 2 || report.Periods[0].Entries != 6 || report.Periods[1].Actions["DELETE_INCIDENT"] != 1 {
+		t.Errorf("expected the deletion counted on the next local day, got %+v", report.Periods)
+	}
+
+	// A trail whose every entry verifies is compliant, even with findings for review
+	clean := &ComplianceReport{Period: "month"}
+	if err := s.check(context.Background(), []AuditDocument{entries[0], entries[6]}, clean); err != nil {
+		t.Fatal(err)
+	}
+	if !clean.Summary.Compliant || clean.Summary.Findings[findingOffHoursDelete] != 1 || len(clean.Periods) != 1 {
+		t.Errorf("expected a compliant trail with a flagged deletion, got %+v", clean.Summary)
+	}
+}
+
+func TestSignComplianceReport(t *testing.T) {
+	_, key, _ := ed25519.GenerateKey(nil)
+	s := &complianceService{signer: newQRSigner(key)}
+	report := &ComplianceReport{ReportID: "COMPLIANCE-20251001T120000Z", Issuer: "did:sih:gateway", Period: "day", Summary: ComplianceSummary{Entries: 1, Verified: 1, Compliant: true}}
+	kid := "did:sih:gateway#" + s.signer.keyID
+
+	signature, _, err := s.sign(report, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims, err := s.signer.verifyJWT(signature, kid); err != nil || claims["report_id"] != report.ReportID {
+		t.Errorf("expected the JSON report signature to verify, got %v %v", claims, err)
+	}
+
+	signature, pdf, err := s.sign(report, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := s.signer.verifyJWT(signature, kid)
+	sum := sha256.Sum256(pdf)
+	if err != nil || claims["pdf_sha256"] != hex.EncodeToString(sum[:]) {
+		t.Errorf("expected the PDF's digest signed, got %v %v", claims, err)
+	}
+}
+
+func TestComplianceRequestValidation(t *testing.T) {
+	cases := []struct {
+		req   ComplianceRequest
+		field string
+	}{
+		{ComplianceRequest{From: "2025-09-01T00:00:00Z", To: "2025-10-01T00:00:00Z"}, "targetId"},
+		{ComplianceRequest{TargetID: "INC-1", Actor: "officer_7", From: "2025-09-01T00:00:00Z", To: "2025-10-01T00:00:00Z"}, "targetId"},
+		{ComplianceRequest{Actor: "officer_7", From: "2025-10-01T00:00:00Z", To: "2025-09-01T00:00:00Z"}, "to"},
+		{ComplianceRequest{Actor: "officer_7", From: "2025-09-01T00:00:00Z", To: "2025-10-01T00:00:00Z", Period: "year"}, "period"},
+	}
+	for _, tc := range cases {
+		if errs := tc.req.Validate(); len(errs) != 1 || errs[0].Field != tc.field {
+			t.Errorf("expected %s rejected, got %v", tc.field, errs)
+		}
+	}
+	if _, _, ok := parseWorkingHours("20:00-08:00"); ok {
+		t.Error("expected working hours to run forward within a day")
+	}
+}
@@ -0,0 +1,170 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"compress/gzip"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// compressibleTypes are the response media types worth gzipping; evidence files, PDFs and XLSX
+// exports are already compressed
+var compressibleTypes = []string{"application/json", "application/problem+json", "application/x-ndjson", "text/"}
+
+// compressionMiddleware gzips responses of at least minBytes for clients that accept it
+func compressionMiddleware(minBytes, level int) gin.HandlerFunc {
+	if _, err := gzip.NewWriterLevel(nil, level); err != nil {
+		panic(fmt.Errorf("COMPRESSION_LEVEL must be between %d and %d", gzip.HuffmanOnly, gzip.BestCompression))
+	}
+	pool := &sync.Pool{New: func() interface{} {
+		gz, _ := gzip.NewWriterLevel(nil, level)
+		return gz
+	}}
+	return func(c *gin.Context) {
+		c.Writer.Header().Add("Vary", "Accept-Encoding")
+		if c.Request.Method == http.MethodHead || !acceptsGzip(c.GetHeader("Accept-Encoding")) {
+			c.Next()
+			return
+		}
+
+		w := &gzipResponseWriter{ResponseWriter: c.Writer, minBytes: minBytes, pool: pool}
+		c.Writer = w
+		defer func() {
+			w.finish()
+			c.Writer = w.ResponseWriter
+		}()
+		c.Next()
+	}
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+		name, value, _ := strings.Cut(strings.TrimSpace(params), "=")
+		if strings.TrimSpace(name) != "q" {
+			return true
+		}
+		q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		return err == nil && q > 0
+	}
+	return false
+}
+
+// gzipResponseWriter holds back the first minBytes of a response so small bodies are sent as they
+// are, then compresses the rest if the status and content type allow it
+type gzipResponseWriter struct {
+	gin.ResponseWriter
+	minBytes int
+	pool     *sync.Pool
+
+	buffer  []byte
+	decided bool
+	gz      *gzip.Writer
+}
+
+func (w *gzipResponseWriter) Write(data []byte) (int, error) {
+	if !w.decided {
+		w.buffer = append(w.buffer, data...)
+		if len(w.buffer) < w.minBytes {
+			return len(data), nil
+		}
+		if err := w.decide(false); err != nil {
+			return 0, err
+		}
+		return len(data), nil
+	}
+	if w.gz != nil {
+		return w.gz.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *gzipResponseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Flush sends whatever has been written so far, so streamed exports keep streaming
+func (w *gzipResponseWriter) Flush() {
+	if !w.decided {
+		w.decide(true)
+	}
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// decide chooses whether to compress and writes out the held-back bytes. Streamed responses are
+// compressed however little has been written when they first flush.
+func (w *gzipResponseWriter) decide(streaming bool) error {
+	w.decided = true
+	header := w.Header()
+	if (streaming || len(w.buffer) >= w.minBytes) && !w.ResponseWriter.Written() && compressible(w.Status(), header) {
+		header.Set("Content-Encoding", "gzip")
+		header.Del("Content-Length")
+		if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			// The compressed bytes differ from the identity encoding the tag was computed over
+			header.Set("ETag", "W/"+etag)
+		}
+		w.gz = w.pool.Get().(*gzip.Writer)
+		w.gz.Reset(w.ResponseWriter)
+	}
+	buffered := w.buffer
+	w.buffer = nil
+	if len(buffered) == 0 {
+		return nil
+	}
+	var err error
+	if w.gz != nil {
+		_, err = w.gz.Write(buffered)
+	} else {
+		_, err = w.ResponseWriter.Write(buffered)
+	}
+	return err
+}
+
+func (w *gzipResponseWriter) finish() {
+	if !w.decided {
+		w.decide(false)
+	}
+	if w.gz != nil {
+		w.gz.Close()
+		w.pool.Put(w.gz)
+		w.gz = nil
+	}
+}
+
+func compressible(status int, header http.Header) bool {
+	if status < http.StatusOK || status == http.StatusNoContent || status == http.StatusPartialContent || status == http.StatusNotModified {
+		return false
+	}
+	if header.Get("Content-Encoding") != "" {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	for _, prefix := range compressibleTypes {
+		if mediaType == prefix || (strings.HasSuffix(prefix, "/") && strings.HasPrefix(mediaType, prefix)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,67 @@
+package gateway
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCompressionAndETags(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(compressionMiddleware(256, gzip.DefaultCompression))
+	large := strings.Repeat("incident ", 100)
+	r.GET("/incident/:id", func(c *gin.Context) {
+		respondTagged(c, IncidentDocument{IncidentID: c.Param("id"), Reporter: large, TxID: "tx-" + c.Param("id")}, "tx-"+c.Param("id"))
+	})
+	r.GET("/small", func(c *gin.Context) { respondData(c, http.StatusOK, "ok") })
+	r.GET("/file", func(c *gin.Context) { c.Data(http.StatusOK, "application/pdf", []byte(large)) })
+
+	get := func(path string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header = header
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("/incident/inc-1", http.Header{"Accept-Encoding": {"br, gzip"}})
+	if w.Header().Get("Content-Encoding") != "gzip" || !strings.Contains(w.Header().Get("Vary"), "Accept-Encoding") {
+		t.Fatalf("expected a gzipped response, got headers %v", w.Header())
+	}
+	gz, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(gz)
+	if !strings.Contains(string(body), `"incident_id":"inc-1"`) {
+		t.Errorf("unexpected body %s", body)
+	}
+	etag := w.Header().Get("ETag")
+	if etag != ledgerETag("tx-inc-1") || !strings.HasPrefix(etag, `W/"`) {
+		t.Errorf("expected a weak ETag from the transaction ID, got %q", etag)
+	}
+
+	for path, header := range map[string]http.Header{
+		"/small":          {"Accept-Encoding": {"gzip"}},
+		"/file":           {"Accept-Encoding": {"gzip"}},
+		"/incident/inc-1": {"Accept-Encoding": {"gzip;q=0, identity"}},
+	} {
+		if w := get(path, header); w.Header().Get("Content-Encoding") != "" || w.Body.Len() == 0 {
+			t.Errorf("%s: expected an uncompressed response, got %v", path, w.Header())
+		}
+	}
+
+	w = get("/incident/inc-1", http.Header{"Accept-Encoding": {"gzip"}, "If-None-Match": {`"other", ` + strings.TrimPrefix(etag, "W/")}})
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 || w.Header().Get("ETag") != etag {
+		t.Errorf("expected 304 without a body, got %d %q", w.Code, w.Body.String())
+	}
+	if w := get("/incident/inc-2", http.Header{"If-None-Match": {etag}}); w.Code != http.StatusOK {
+		t.Errorf("expected another incident's version to be sent, got %d", w.Code)
+	}
+}
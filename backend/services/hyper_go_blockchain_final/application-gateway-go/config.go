@@ -0,0 +1,91 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// getEnv returns the value of an environment variable, or fallback when it is unset or empty
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// getEnvInt parses an integer environment variable, logging and falling back on bad input
+func getEnvInt(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Ignoring invalid %s=%q: %v", key, value, err)
+		return fallback
+	}
+	return parsed
+}
+
+// getEnvDuration parses a Go duration environment variable such as "30s"
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Ignoring invalid %s=%q: %v", key, value, err)
+		return fallback
+	}
+	return parsed
+}
+
+// getEnvBool parses a boolean environment variable
+func getEnvBool(key string, fallback bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("Ignoring invalid %s=%q: %v", key, value, err)
+		return fallback
+	}
+	return parsed
+}
+
+// getEnvFloat parses a floating-point environment variable
+func getEnvFloat(key string, fallback float64) float64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.Printf("Ignoring invalid %s=%q: %v", key, value, err)
+		return fallback
+	}
+	return parsed
+}
+
+// parseKeyValues parses a comma-separated list of key=value pairs
+func parseKeyValues(raw string) map[string]string {
+	values := map[string]string{}
+	for _, pair := range strings.Split(raw, ",") {
+		key, value, ok := strings.Cut(pair, "=")
+		if ok && strings.TrimSpace(key) != "" {
+			values[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+	return values
+}
@@ -2,7 +2,7 @@
 Copyright 2022 IBM All Rights Reserved.
 SPDX-License-Identifier: Apache-2.0
 */
-package main
+package gateway
 
 import (
 	"crypto/x509"
@@ -24,7 +24,9 @@ const (
 	gatewayPeer  = "peer0.org1.example.com"
 )
 
-// newGrpcConnection creates a gRPC connection to the Gateway server.
+// newGrpcConnection creates a gRPC connection to the Gateway server, or with
+// FABRIC_DISCOVERY_ENABLED to whichever discovered peer is available, or with FABRIC_PEERS to
+// whichever listed peer is, those in GATEWAY_REGION first.
 func newGrpcConnection() *grpc.ClientConn {
 	certificatePEM, err := os.ReadFile(tlsCertPath)
 	if err != nil {
@@ -34,6 +36,16 @@ func newGrpcConnection() *grpc.ClientConn {
 	if err != nil {
 		panic(err)
 	}
+	regions, err = parsePeerRegions(getEnv("GATEWAY_REGION", ""), getEnv("FABRIC_PEERS", ""))
+	if err != nil {
+		panic(fmt.Errorf("invalid FABRIC_PEERS: %w", err))
+	}
+	if getEnvBool("FABRIC_DISCOVERY_ENABLED", false) {
+		return newDiscoveryConnection(certificate)
+	}
+	if len(regions.order) > 0 {
+		return newRegionalConnection(certificate)
+	}
 	certPool := x509.NewCertPool()
 	certPool.AddCert(certificate)
 	transportCredentials := credentials.NewClientTLSFromCert(certPool, gatewayPeer)
@@ -46,6 +58,15 @@ func newGrpcConnection() *grpc.ClientConn {
 
 // newIdentity creates a client identity for this Gateway connection using an X.509 certificate.
 func newIdentity() *identity.X509Identity {
+	id, err := identity.NewX509Identity(mspID, gatewayCertificate())
+	if err != nil {
+		panic(err)
+	}
+	return id
+}
+
+// gatewayCertificate reads the gateway identity's certificate from its MSP directory
+func gatewayCertificate() *x509.Certificate {
 	certificatePEM, err := readFirstFile(certPath)
 	if err != nil {
 		panic(fmt.Errorf("failed to read certificate file: %w", err))
@@ -54,11 +75,7 @@ func newIdentity() *identity.X509Identity {
 	if err != nil {
 		panic(err)
 	}
-	id, err := identity.NewX509Identity(mspID, certificate)
-	if err != nil {
-		panic(err)
-	}
-	return id
+	return certificate
 }
 
 func loadCertificate(filename string) (*x509.Certificate, error) {
@@ -69,19 +86,45 @@ func loadCertificate(filename string) (*x509.Certificate, error) {
 	return identity.CertificateFromPEM(certificatePEM)
 }
 
-// newSign creates a function that generates a digital signature from a message digest using a private key.
+// newSign creates a function that generates a digital signature from a message digest, using the
+// private key on disk or, with GATEWAY_SIGNER, the matching key on an HSM or in a cloud KMS.
 func newSign() identity.Sign {
+	switch signer := getEnv("GATEWAY_SIGNER", "file"); signer {
+	case "pkcs11":
+		return newGatewayHSMSign()
+	case "aws-kms", "gcp-kms":
+		return newGatewayKMSSign(signer)
+	}
+
+	sign, err := newFileSign()
+	if err != nil {
+		panic(err)
+	}
+	return sign
+}
+
+// newFileSign signs with the gateway identity's private key from its MSP keystore
+func newFileSign() (identity.Sign, error) {
 	privateKeyPEM, err := readFirstFile(keyPath)
 	if err != nil {
-		panic(fmt.Errorf("failed to read private key file: %w", err))
+		return nil, fmt.Errorf("failed to read private key file: %w", err)
 	}
 	privateKey, err := identity.PrivateKeyFromPEM(privateKeyPEM)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	sign, err := identity.NewPrivateKeySign(privateKey)
+	return identity.NewPrivateKeySign(privateKey)
+}
+
+// newGatewayHSMSign signs as the gateway identity with the key held on the PKCS#11 token
+func newGatewayHSMSign() identity.Sign {
+	opts, err := hsmConfig{}.options(gatewayCertificate())
 	if err != nil {
-		panic(err)
+		panic(fmt.Errorf("failed to configure HSM signing: %w", err))
+	}
+	sign, err := newHSMSign(opts)
+	if err != nil {
+		panic(fmt.Errorf("failed to open HSM signer: %w", err))
 	}
 	return sign
 }
@@ -0,0 +1,450 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const errCodeConsentRequired = "CONSENT_REQUIRED"
+
+// Consent purposes. Each is granted, renewed and withdrawn on its own.
+const (
+	purposeLocationTracking = "location_tracking"
+	purposeFamilySharing    = "family_sharing"
+	purposeDataRetention    = "data_retention"
+)
+
+var consentPurposes = []string{purposeLocationTracking, purposeFamilySharing, purposeDataRetention}
+
+// consentEnforced makes location ingestion, family notifications and artifact retention check the
+// tourist's consents. Off by default so deployments can backfill consents first.
+var consentEnforced bool
+
+// ConsentDocument is a tourist's consent to one purpose, as the chaincode stores it
+type ConsentDocument struct {
+	DocType       string `json:"doc_type"`
+	ConsentID     string `json:"consent_id"`
+	DigitalID     string `json:"digital_id"`
+	Purpose       string `json:"purpose"`
+	Action        string `json:"action"`
+	Status        string `json:"status"`
+	PolicyVersion string `json:"policy_version"`
+	GrantedAt     string `json:"granted_at"`
+	ExpiresAt     string `json:"expires_at"`
+	WithdrawnAt   string `json:"withdrawn_at,omitempty"`
+	Version       int    `json:"version"`
+	PreviousHash  string `json:"previous_hash,omitempty"`
+	RecordHash    string `json:"record_hash"`
+	UpdatedBy     string `json:"updated_by"`
+	UpdatedAt     string `json:"updated_at"`
+	OwnerOrg      string `json:"owner_org,omitempty"`
+	TxID          string `json:"tx_id"`
+}
+
+// active reports whether the consent is granted and unexpired at t
+func (d ConsentDocument) active(t time.Time) bool {
+	expires, err := time.Parse(time.RFC3339, d.ExpiresAt)
+	return d.Status == "granted" && err == nil && expires.After(t)
+}
+
+// consentRecordHash hashes the fields of a consent change, including the previous version's hash,
+// so each version commits to the whole history before it
+func consentRecordHash(d ConsentDocument) string {
+	record, _ := json.Marshal([]interface{}{
+		d.ConsentID, d.DigitalID, d.Purpose, d.Action, d.Status, d.PolicyVersion, d.GrantedAt,
+		d.ExpiresAt, d.WithdrawnAt, d.Version, d.PreviousHash, d.UpdatedBy, d.UpdatedAt,
+	})
+	sum := sha256.Sum256(record)
+	return hex.EncodeToString(sum[:])
+}
+
+func consentID(digitalID, purpose string) string {
+	return "CONSENT:" + digitalID + ":" + purpose
+}
+
+// ConsentVersion is one committed version of a consent
+type ConsentVersion struct {
+	TxID      string           `json:"tx_id"`
+	Timestamp string           `json:"timestamp"`
+	Deleted   bool             `json:"deleted"`
+	Consent   *ConsentDocument `json:"consent,omitempty"`
+}
+
+// ConsentHistory is a consent's versions, with the result of re-hashing the chain
+type ConsentHistory struct {
+	ConsentID string           `json:"consent_id"`
+	Versions  []ConsentVersion `json:"versions"`
+	Intact    bool             `json:"intact"`
+	// BrokenAt is the first version whose hash or link does not match
+	BrokenAt int `json:"broken_at,omitempty"`
+}
+
+// ConsentCheck answers whether a service may process a tourist's data for a purpose
+type ConsentCheck struct {
+	DigitalID string `json:"digital_id"`
+	Purpose   string `json:"purpose"`
+	Granted   bool   `json:"granted"`
+	Enforced  bool   `json:"enforced"`
+	ExpiresAt string `json:"expires_at,omitempty"`
+}
+
+// GrantConsentRequest grants consent to a purpose under a policy version
+type GrantConsentRequest struct {
+	Purpose       string `json:"purpose" binding:"required"`
+	PolicyVersion string `json:"policyVersion" binding:"required"`
+	ExpiresAt     string `json:"expiresAt" binding:"required"`
+	Actor         string `json:"actor" binding:"required"`
+}
+
+func (r GrantConsentRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.oneOf("purpose", r.Purpose, consentPurposes)
+	v.identifier("policyVersion", r.PolicyVersion)
+	v.future("expiresAt", r.ExpiresAt)
+	v.identifier("actor", r.Actor)
+	return v.errors
+}
+
+// RenewConsentRequest extends a granted consent
+type RenewConsentRequest struct {
+	Purpose   string `json:"purpose" binding:"required"`
+	ExpiresAt string `json:"expiresAt" binding:"required"`
+	Actor     string `json:"actor" binding:"required"`
+}
+
+func (r RenewConsentRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.oneOf("purpose", r.Purpose, consentPurposes)
+	v.future("expiresAt", r.ExpiresAt)
+	v.identifier("actor", r.Actor)
+	return v.errors
+}
+
+// WithdrawConsentRequest withdraws a granted consent
+type WithdrawConsentRequest struct {
+	Purpose string `json:"purpose" binding:"required"`
+	Actor   string `json:"actor" binding:"required"`
+}
+
+func (r WithdrawConsentRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.oneOf("purpose", r.Purpose, consentPurposes)
+	v.identifier("actor", r.Actor)
+	return v.errors
+}
+
+// ConsentCheckRequest names the purpose a service wants to process data for, or whose history to read
+type ConsentCheckRequest struct {
+	Purpose string `form:"purpose"`
+}
+
+func (r ConsentCheckRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.oneOf("purpose", r.Purpose, consentPurposes)
+	return v.errors
+}
+
+// consentRequiredError is returned when enforcement is on and the tourist has not consented
+type consentRequiredError struct {
+	digitalID, purpose string
+}
+
+func (e *consentRequiredError) Error() string {
+	return fmt.Sprintf("%s has not consented to %s", e.digitalID, e.purpose)
+}
+
+func initConsent() {
+	consentEnforced = getEnvBool("CONSENT_ENFORCEMENT", false)
+	if !consentEnforced {
+		log.Println("📜 CONSENT_ENFORCEMENT not set, consents are recorded but not enforced")
+		return
+	}
+	if emergencyContacts != nil {
+		emergencyContacts = consentedContacts{emergencyContacts}
+	}
+	log.Printf("📜 Enforcing consent to %v", consentPurposes)
+}
+
+// consentedContacts withholds the emergency contacts of tourists who have not consented to family
+// sharing, so nobody is notified on their behalf
+type consentedContacts struct {
+	contactDirectory
+}
+
+func (d consentedContacts) contacts(ctx context.Context, digitalID string) ([]EmergencyContact, error) {
+	if err := requireConsent(ctx, digitalID, purposeFamilySharing); err != nil {
+		var required *consentRequiredError
+		if errors.As(err, &required) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return d.contactDirectory.contacts(ctx, digitalID)
+}
+
+// requireConsent is the enforcement hook services call before processing a tourist's data for a
+// purpose. It returns nil when enforcement is off.
+func requireConsent(ctx context.Context, digitalID, purpose string) error {
+	if !consentEnforced {
+		return nil
+	}
+	granted, err := ledger.consentGranted(ctx, digitalID, purpose)
+	if err != nil {
+		return err
+	}
+	if !granted {
+		return &consentRequiredError{digitalID: digitalID, purpose: purpose}
+	}
+	return nil
+}
+
+// Consent operations
+
+// consentGranted reports whether a consent is active, reading it through the document cache
+func (s ledgerService) consentGranted(ctx context.Context, digitalID, purpose string) (bool, error) {
+	consent, err := s.GetConsent(ctx, digitalID, purpose)
+	if err != nil {
+		if translateFabricError(err).Code == errCodeNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	return consent.active(time.Now()), nil
+}
+
+func (s ledgerService) GetConsent(ctx context.Context, digitalID, purpose string) (ConsentDocument, error) {
+	result, err := s.readDocument(ctx, "ReadConsent", consentID(digitalID, purpose))
+	if err != nil {
+		return ConsentDocument{}, err
+	}
+	return decodeDocument[ConsentDocument](result, "consent")
+}
+
+func (ledgerService) ListConsents(ctx context.Context, digitalID string) ([]ConsentDocument, error) {
+	var v fieldValidator
+	v.digitalID("id", digitalID)
+	if len(v.errors) > 0 {
+		return nil, v.errors
+	}
+	result, err := evaluateTransaction(ctx, "GetConsentsByDID", digitalID)
+	if err != nil {
+		return nil, err
+	}
+	return decodeDocument[[]ConsentDocument](result, "consent")
+}
+
+// ConsentHistory reads every version of a consent and re-hashes the chain
+func (ledgerService) ConsentHistory(ctx context.Context, digitalID, purpose string) (ConsentHistory, error) {
+	var v fieldValidator
+	v.digitalID("id", digitalID)
+	v.oneOf("purpose", purpose, consentPurposes)
+	if len(v.errors) > 0 {
+		return ConsentHistory{}, v.errors
+	}
+	id := consentID(digitalID, purpose)
+	result, err := evaluateTransaction(ctx, "GetConsentHistory", id)
+	if err != nil {
+		return ConsentHistory{}, err
+	}
+	versions, err := decodeDocument[[]ConsentVersion](result, "consent history")
+	if err != nil {
+		return ConsentHistory{}, err
+	}
+	history := ConsentHistory{ConsentID: id, Versions: versions}
+	history.Intact, history.BrokenAt = verifyConsentChain(versions)
+	return history, nil
+}
+
+// verifyConsentChain re-hashes each version and checks it links to the one before, returning the
+// first version that does not
+func verifyConsentChain(versions []ConsentVersion) (bool, int) {
+	previous := ""
+	for _, version := range versions {
+		if version.Consent == nil {
+			continue
+		}
+		if version.Consent.PreviousHash != previous || consentRecordHash(*version.Consent) != version.Consent.RecordHash {
+			return false, version.Consent.Version
+		}
+		previous = version.Consent.RecordHash
+	}
+	return true, 0
+}
+
+func (s ledgerService) GrantConsent(ctx context.Context, digitalID string, req GrantConsentRequest) (ConsentDocument, *TransactionResult, error) {
+	if err := validateConsentChange(digitalID, req); err != nil {
+		return ConsentDocument{}, nil, err
+	}
+	return s.changeConsent(ctx, digitalID, req.Purpose, req.Actor, func(d *ConsentDocument, now string) []string {
+		d.Action, d.Status, d.PolicyVersion = "grant", "granted", req.PolicyVersion
+		d.GrantedAt, d.ExpiresAt, d.WithdrawnAt = now, ledgerTimestamp(req.ExpiresAt), ""
+		return []string{"GrantConsent", digitalID, req.Purpose, d.PolicyVersion, d.ExpiresAt}
+	})
+}
+
+func (s ledgerService) RenewConsent(ctx context.Context, digitalID string, req RenewConsentRequest) (ConsentDocument, *TransactionResult, error) {
+	if err := validateConsentChange(digitalID, req); err != nil {
+		return ConsentDocument{}, nil, err
+	}
+	return s.changeConsent(ctx, digitalID, req.Purpose, req.Actor, func(d *ConsentDocument, _ string) []string {
+		d.Action, d.ExpiresAt = "renew", ledgerTimestamp(req.ExpiresAt)
+		return []string{"RenewConsent", digitalID, req.Purpose, d.ExpiresAt}
+	})
+}
+
+// WithdrawConsent withdraws a consent. Withdrawing data retention also deletes the tourist's KYC
+// artifacts; the ledger keeps only hashes.
+func (s ledgerService) WithdrawConsent(ctx context.Context, digitalID string, req WithdrawConsentRequest) (ConsentDocument, *TransactionResult, error) {
+	if err := validateConsentChange(digitalID, req); err != nil {
+		return ConsentDocument{}, nil, err
+	}
+	consent, result, err := s.changeConsent(ctx, digitalID, req.Purpose, req.Actor, func(d *ConsentDocument, now string) []string {
+		d.Action, d.Status, d.WithdrawnAt = "withdraw", "withdrawn", now
+		return []string{"WithdrawConsent", digitalID, req.Purpose}
+	})
+	if err == nil && req.Purpose == purposeDataRetention && onboarding != nil {
+		if err := onboarding.purgeArtifacts(ctx, digitalID); err != nil {
+			logWithContext(ctx, "Failed to purge KYC artifacts of %s: %v", digitalID, err)
+		}
+	}
+	return consent, result, err
+}
+
+func validateConsentChange(digitalID string, req validatable) error {
+	var v fieldValidator
+	v.digitalID("id", digitalID)
+	errs := append(v.errors, req.Validate()...)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// changeConsent reads the current version, applies change to it and submits the result with its
+// record hash. change returns the transaction name and its leading arguments; the chaincode
+// refuses the change if another one committed since the read.
+func (s ledgerService) changeConsent(ctx context.Context, digitalID, purpose, actor string, change func(d *ConsentDocument, now string) []string) (ConsentDocument, *TransactionResult, error) {
+	current, err := s.GetConsent(ctx, digitalID, purpose)
+	if err != nil && translateFabricError(err).Code != errCodeNotFound {
+		return ConsentDocument{}, nil, err
+	}
+	next := current
+	if err != nil {
+		next = ConsentDocument{DocType: "consent", ConsentID: consentID(digitalID, purpose), DigitalID: digitalID, Purpose: purpose}
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	args := change(&next, now)
+	next.Version++
+	next.PreviousHash, next.UpdatedBy, next.UpdatedAt = current.RecordHash, actor, now
+	next.RecordHash = consentRecordHash(next)
+
+	args = append(args, now, next.PreviousHash, next.RecordHash, actor)
+	result, err := submitAndInvalidate(ctx, next.ConsentID, args[0], args[1:]...)
+	if err != nil {
+		return ConsentDocument{}, nil, err
+	}
+	next.TxID = result.TxID
+	return next, result, nil
+}
+
+func listConsents(c *gin.Context) {
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+
+	consents, err := ledger.ListConsents(c.Request.Context(), id)
+	if err != nil {
+		respondServiceError(c, "Failed to list consents", err)
+		return
+	}
+
+	respondData(c, http.StatusOK, consents)
+}
+
+func getConsentHistory(c *gin.Context) {
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+
+	var req ConsentCheckRequest
+	if !bindQuery(c, &req) {
+		return
+	}
+
+	history, err := ledger.ConsentHistory(c.Request.Context(), id, req.Purpose)
+	if err != nil {
+		respondServiceError(c, "Failed to read consent history", err)
+		return
+	}
+
+	respondData(c, http.StatusOK, history)
+}
+
+// checkConsent is the enforcement hook for services outside the gateway. Granted reflects the
+// ledger whether or not this gateway enforces consent.
+func checkConsent(c *gin.Context) {
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	var req ConsentCheckRequest
+	if !bindQuery(c, &req) {
+		return
+	}
+
+	check := ConsentCheck{DigitalID: id, Purpose: req.Purpose, Enforced: consentEnforced}
+	consent, err := ledger.GetConsent(c.Request.Context(), id, req.Purpose)
+	if err != nil && translateFabricError(err).Code != errCodeNotFound {
+		respondServiceError(c, "Failed to check consent", err)
+		return
+	}
+	if err == nil && consent.active(time.Now()) {
+		check.Granted, check.ExpiresAt = true, consent.ExpiresAt
+	}
+
+	respondData(c, http.StatusOK, check)
+}
+
+// consentHandler binds a consent change, submits it and responds with the new version and the
+// receipt issued for it
+func consentHandler[R validatable](action string, apply func(ctx context.Context, digitalID string, req R) (ConsentDocument, *TransactionResult, error)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := validPathID(c, "id")
+		if !ok {
+			return
+		}
+		var req R
+		if !bindRequest(c, &req) {
+			return
+		}
+		setAuditTarget(c, id)
+		if action == "withdraw" && !passkeyAsserted(c, id, passkeyActionConsentWithdraw) {
+			return
+		}
+
+		consent, result, err := apply(c.Request.Context(), id, req)
+		if err != nil {
+			respondServiceError(c, "Failed to "+action+" consent", err)
+			return
+		}
+
+		respondCommitted(c, http.StatusOK, ConsentChange{ConsentDocument: consent, Receipt: issueReceipt(c.Request.Context(), consent)}, result)
+	}
+}
@@ -0,0 +1,92 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestConsentHashChain(t *testing.T) {
+	did := "did:sih:tourist1"
+	grant := ConsentDocument{
+		ConsentID: consentID(did, purposeLocationTracking), DigitalID: did, Purpose: purposeLocationTracking,
+		Action: "grant", Status: "granted", PolicyVersion: "v1", GrantedAt: "2025-01-01T10:00:00Z",
+		ExpiresAt: "2025-07-01T00:00:00Z", Version: 1, UpdatedBy: "tourist", UpdatedAt: "2025-01-01T10:00:00Z",
+	}
+	grant.RecordHash = consentRecordHash(grant)
+
+	renew := grant
+	renew.Action, renew.ExpiresAt, renew.Version = "renew", "2026-01-01T00:00:00Z", 2
+	renew.PreviousHash, renew.UpdatedAt = grant.RecordHash, "2025-06-01T10:00:00Z"
+	renew.RecordHash = consentRecordHash(renew)
+
+	withdraw := renew
+	withdraw.Action, withdraw.Status, withdraw.WithdrawnAt, withdraw.Version = "withdraw", "withdrawn", "2025-08-01T10:00:00Z", 3
+	withdraw.PreviousHash, withdraw.UpdatedAt = renew.RecordHash, "2025-08-01T10:00:00Z"
+	withdraw.RecordHash = consentRecordHash(withdraw)
+
+	versions := []ConsentVersion{{Consent: &grant}, {Consent: &renew}, {Consent: &withdraw}}
+	if intact, _ := verifyConsentChain(versions); !intact {
+		t.Fatal("expected the chain to verify")
+	}
+	if !renew.active(time.Date(2025, 9, 1, 0, 0, 0, 0, time.UTC)) || withdraw.active(time.Date(2025, 9, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected only the renewed consent to be active")
+	}
+
+	// Rewriting an old version breaks the chain there
+	tampered := renew
+	tampered.ExpiresAt = "2030-01-01T00:00:00Z"
+	versions[1].Consent = &tampered
+	if intact, brokenAt := verifyConsentChain(versions); intact || brokenAt != 2 {
+		t.Errorf("expected the chain to break at version 2, got %v, %d", intact, brokenAt)
+	}
+}
+
+func TestConsentEnforcement(t *testing.T) {
+	ctx := context.Background()
+	if err := requireConsent(ctx, "did:sih:tourist1", purposeLocationTracking); err != nil {
+		t.Fatalf("expected no check while enforcement is off, got %v", err)
+	}
+
+	var required *consentRequiredError
+	if err := error(&consentRequiredError{"did:sih:tourist1", purposeFamilySharing}); !errors.As(err, &required) || required.purpose != purposeFamilySharing {
+		t.Errorf("unexpected error %v", err)
+	}
+	if errs := (GrantConsentRequest{Purpose: "marketing", PolicyVersion: "v1", ExpiresAt: "2020-01-01T00:00:00Z", Actor: "tourist"}).Validate(); len(errs) != 2 {
+		t.Errorf("expected the purpose and expiry to be rejected, got %v", errs)
+	}
+}
+
+func TestKYCArtifactPurge(t *testing.T) {
+	store, err := newLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := evidenceStore
+	evidenceStore = store
+	defer func() { evidenceStore = previous }()
+	ctx := context.Background()
+
+	k := &kycService{pepper: []byte("0123456789abcdef"), issuer: "kyc-onboarding", didMethod: "sih", verifier: &unverifiedKYC{}, queue: newMemoryReviewQueue()}
+	req := KYCApplicationRequest{
+		DocumentType: "aadhaar", DocumentNumber: "234123412346", Nationality: "IN", FullName: "Asha Devi", DateOfBirth: "1990-04-01",
+		ExpiresAt: time.Now().Add(time.Hour).UTC().Format(time.RFC3339), Consent: KYCConsent{Purposes: []string{purposeDataRetention}, PolicyVersion: "v1"},
+	}
+	selfie := kycUpload{name: "selfie", mediaType: "image/png", data: []byte("\x89PNG\r\n\x1a\nselfie")}
+	app, _, err := k.submit(ctx, req, []kycUpload{selfie})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := k.purgeArtifacts(ctx, app.DigitalID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(ctx, kycArtifactKey(app.ApplicationID, "selfie")); !errors.Is(err, errObjectNotFound) {
+		t.Errorf("expected the selfie to be deleted, got %v", err)
+	}
+	stored, _ := k.load(ctx, app.ApplicationID)
+	if stored == nil || stored.ArtifactsPurgedAt == "" || len(stored.Artifacts) != 1 {
+		t.Errorf("expected the application to record the purge and keep the artifact hash, got %+v", stored)
+	}
+}
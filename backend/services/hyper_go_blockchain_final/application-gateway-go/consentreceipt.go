@@ -0,0 +1,665 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// consentReceiptVersion is the Kantara Initiative Consent Receipt Specification the receipts follow
+	consentReceiptVersion = "KI-CR-v1.1.0"
+
+	errCodeReceiptMismatch = "RECEIPT_MISMATCH"
+)
+
+var (
+	consentReceiptFormats = []string{"json", "pdf"}
+
+	errConsentReceiptMismatch = errors.New("a different receipt was already issued for this consent version")
+)
+
+// ConsentReceipt is the receipt a tourist is given for one version of a consent, in the layout of
+// the Kantara Consent Receipt Specification v1.1. Consent is an extension naming the ledger
+// version it describes. A receipt is built only from that version and the controller's
+// configuration, so it can be rebuilt byte for byte.
+type ConsentReceipt struct {
+	Version          string                `json:"version"`
+	Jurisdiction     string                `json:"jurisdiction"`
+	ConsentTimestamp int64                 `json:"consentTimestamp"`
+	CollectionMethod string                `json:"collectionMethod"`
+	ConsentReceiptID string                `json:"consentReceiptID"`
+	Language         string                `json:"language"`
+	PiiPrincipalID   string                `json:"piiPrincipalId"`
+	PiiControllers   []ConsentController   `json:"piiControllers"`
+	PolicyURL        string                `json:"policyUrl"`
+	Services         []ConsentService      `json:"services"`
+	Sensitive        bool                  `json:"sensitive"`
+	SpiCat           []string              `json:"spiCat"`
+	Consent          ConsentReceiptVersion `json:"consent"`
+}
+
+// ConsentController is the organization that decides how the tourist's data is processed
+type ConsentController struct {
+	PiiController    string `json:"piiController"`
+	Contact          string `json:"contact"`
+	Address          string `json:"address"`
+	Email            string `json:"email"`
+	Phone            string `json:"phone,omitempty"`
+	PiiControllerURL string `json:"piiControllerUrl,omitempty"`
+}
+
+// ConsentService is a service the tourist consented to, with its purposes
+type ConsentService struct {
+	Service  string                  `json:"service"`
+	Purposes []ConsentReceiptPurpose `json:"purposes"`
+}
+
+// ConsentReceiptPurpose is what the tourist agreed to: the purpose, the categories of data it
+// covers, how the consent ends and how long the data is kept
+type ConsentReceiptPurpose struct {
+	Purpose              string   `json:"purpose"`
+	PurposeCategory      []string `json:"purposeCategory"`
+	ConsentType          string   `json:"consentType"`
+	PiiCategory          []string `json:"piiCategory"`
+	PrimaryPurpose       bool     `json:"primaryPurpose"`
+	Termination          string   `json:"termination"`
+	Retention            string   `json:"retention"`
+	ThirdPartyDisclosure bool     `json:"thirdPartyDisclosure"`
+	ThirdPartyName       string   `json:"thirdPartyName,omitempty"`
+}
+
+// ConsentReceiptVersion names the consent version a receipt describes, by its record hash
+type ConsentReceiptVersion struct {
+	ConsentID     string `json:"consentId"`
+	Action        string `json:"action"`
+	Status        string `json:"status"`
+	PolicyVersion string `json:"policyVersion"`
+	GrantedAt     string `json:"grantedAt"`
+	ExpiresAt     string `json:"expiresAt"`
+	WithdrawnAt   string `json:"withdrawnAt,omitempty"`
+	Version       int    `json:"version"`
+	RecordHash    string `json:"recordHash"`
+	TxID          string `json:"txId"`
+}
+
+// ConsentReceiptDocument is a receipt's hash anchored on the ledger, as the chaincode stores it
+type ConsentReceiptDocument struct {
+	DocType           string `json:"doc_type"`
+	ReceiptID         string `json:"receipt_id"`
+	ConsentID         string `json:"consent_id"`
+	DigitalID         string `json:"digital_id"`
+	Purpose           string `json:"purpose"`
+	Action            string `json:"action"`
+	ConsentVersion    int    `json:"consent_version"`
+	ConsentRecordHash string `json:"consent_record_hash"`
+	ReceiptHash       string `json:"receipt_hash"`
+	IssuedBy          string `json:"issued_by"`
+	IssuedAt          string `json:"issued_at"`
+	OwnerOrg          string `json:"owner_org,omitempty"`
+	TxID              string `json:"tx_id"`
+}
+
+// IssuedConsentReceipt is a receipt with its ledger anchor. Verified is set when the receipt
+// matches the anchored hash and the anchored consent version is in the consent's intact chain.
+type IssuedConsentReceipt struct {
+	Receipt  ConsentReceipt         `json:"receipt"`
+	Anchor   ConsentReceiptDocument `json:"anchor"`
+	Verified bool                   `json:"verified"`
+	Reason   string                 `json:"reason,omitempty"`
+}
+
+// ConsentChange is a committed consent version with the receipt issued for it. Receipt is absent
+// when the receipt could not be issued; it can be issued again with POST /consents/:id/receipts.
+type ConsentChange struct {
+	ConsentDocument
+	Receipt *IssuedConsentReceipt `json:"receipt,omitempty"`
+}
+
+// ConsentReceiptListRequest narrows a tourist's receipts to one purpose
+type ConsentReceiptListRequest struct {
+	Purpose string `form:"purpose"`
+}
+
+func (r ConsentReceiptListRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	if r.Purpose != "" {
+		v.oneOf("purpose", r.Purpose, consentPurposes)
+	}
+	return v.errors
+}
+
+// ConsentReceiptRequest picks the format a receipt is downloaded in
+type ConsentReceiptRequest struct {
+	Format string `form:"format"`
+}
+
+func (r ConsentReceiptRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	if r.Format != "" {
+		v.oneOf("format", r.Format, consentReceiptFormats)
+	}
+	return v.errors
+}
+
+// IssueConsentReceiptRequest issues the receipt for a consent's current version again, for one
+// that failed to issue with the change or was lost from storage
+type IssueConsentReceiptRequest struct {
+	Purpose string `json:"purpose" binding:"required"`
+	Actor   string `json:"actor" binding:"required"`
+}
+
+func (r IssueConsentReceiptRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.oneOf("purpose", r.Purpose, consentPurposes)
+	v.identifier("actor", r.Actor)
+	return v.errors
+}
+
+// consentTerms is what a tourist agrees to when consenting to a purpose
+type consentTerms struct {
+	service     string
+	description string
+	category    string
+	piiCategory []string
+	retention   string
+	thirdParty  string
+}
+
+var consentPurposeTerms = map[string]consentTerms{
+	purposeLocationTracking: {
+		service:     "Tourist safety monitoring",
+		description: "Tracking the tourist's location to raise geo-fence and anomaly alerts and to locate them in an emergency",
+		category:    "Safety and emergency response",
+		piiCategory: []string{"Location", "Device identifiers"},
+		retention:   "Location history is kept off-chain and only its hashes are anchored on the ledger; pings stop being taken when this consent ends",
+	},
+	purposeFamilySharing: {
+		service:     "Family notifications",
+		description: "Telling the tourist's emergency contacts about SOS alerts and escalations, and sharing their coarse location through family links",
+		category:    "Safety and emergency response",
+		piiCategory: []string{"Coarse location", "Safety status", "Emergency contact details"},
+		retention:   "Family links expire when their time box ends; notifications are not kept",
+		thirdParty:  "Emergency contacts named by the tourist",
+	},
+	purposeDataRetention: {
+		service:     "Identity verification",
+		description: "Keeping the identity documents and selfie submitted at onboarding so the Digital ID can be verified",
+		category:    "Identity verification",
+		piiCategory: []string{"Identity documents", "Biometric image", "Contact details"},
+		retention:   "Artifacts are kept until this consent is withdrawn, which deletes them; only their hashes stay on the ledger",
+	},
+}
+
+// consentReceiptIssuer builds receipts for consent changes, stores them with the evidence files and
+// anchors their hashes on the ledger
+type consentReceiptIssuer struct {
+	controller   ConsentController
+	policyURL    string
+	jurisdiction string
+	language     string
+	method       string
+}
+
+var consentReceipts *consentReceiptIssuer
+
+// initConsentReceipts reads the controller named on every receipt. Changing it changes the
+// receipts built from then on, so lost receipts of earlier versions can no longer be rebuilt.
+func initConsentReceipts() {
+	consentReceipts = &consentReceiptIssuer{
+		controller: ConsentController{
+			PiiController:    getEnv("CONSENT_CONTROLLER_NAME", "Tourist Safety Authority"),
+			Contact:          getEnv("CONSENT_CONTROLLER_CONTACT", "Data Protection Officer"),
+			Address:          getEnv("CONSENT_CONTROLLER_ADDRESS", ""),
+			Email:            getEnv("CONSENT_CONTROLLER_EMAIL", ""),
+			Phone:            getEnv("CONSENT_CONTROLLER_PHONE", ""),
+			PiiControllerURL: getEnv("CONSENT_CONTROLLER_URL", ""),
+		},
+		policyURL:    getEnv("CONSENT_POLICY_URL", ""),
+		jurisdiction: getEnv("CONSENT_JURISDICTION", "IN"),
+		language:     getEnv("CONSENT_RECEIPT_LANGUAGE", "en"),
+		method:       getEnv("CONSENT_COLLECTION_METHOD", "Tourist safety app"),
+	}
+	if consentReceipts.controller.Email == "" || consentReceipts.policyURL == "" {
+		log.Println("⚠️  CONSENT_CONTROLLER_EMAIL or CONSENT_POLICY_URL not set; consent receipts will not say how to reach the controller")
+	}
+	log.Printf("📜 Issuing consent receipts for %s", consentReceipts.controller.PiiController)
+}
+
+// consentReceiptID is the ID of the receipt for a consent version
+func consentReceiptID(consentID string, version int) string {
+	return fmt.Sprintf("CR:%s:v%d", consentID, version)
+}
+
+// consentReceiptKey is where a receipt lives in the evidence store
+func consentReceiptKey(digitalID, receiptID string) string {
+	return fmt.Sprintf("consent-receipts/%s/%s.json", digitalID, receiptID)
+}
+
+// consentReceiptHash is the SHA-256 anchored for a receipt, over the JSON the tourist is given
+func consentReceiptHash(receipt []byte) string {
+	sum := sha256.Sum256(receipt)
+	return hex.EncodeToString(sum[:])
+}
+
+// build returns the receipt for a consent version
+func (r *consentReceiptIssuer) build(consent ConsentDocument) ConsentReceipt {
+	terms := consentPurposeTerms[consent.Purpose]
+	timestamp, _ := time.Parse(time.RFC3339, consent.UpdatedAt)
+
+	termination := fmt.Sprintf("Expires at %s unless renewed; can be withdrawn at any time from the app", consent.ExpiresAt)
+	if consent.Status == "withdrawn" {
+		termination = fmt.Sprintf("Withdrawn at %s; processing for this purpose has stopped", consent.WithdrawnAt)
+	}
+	retention := terms.retention
+	if eraser != nil && eraser.window > 0 {
+		retention += fmt.Sprintf(". Off-chain personal data is erased %s after the Digital ID expires", eraser.window)
+	}
+	return ConsentReceipt{
+		Version:          consentReceiptVersion,
+		Jurisdiction:     r.jurisdiction,
+		ConsentTimestamp: timestamp.Unix(),
+		CollectionMethod: r.method,
+		ConsentReceiptID: consentReceiptID(consent.ConsentID, consent.Version),
+		Language:         r.language,
+		PiiPrincipalID:   consent.DigitalID,
+		PiiControllers:   []ConsentController{r.controller},
+		PolicyURL:        r.policyURL,
+		Services: []ConsentService{{
+			Service: terms.service,
+			Purposes: []ConsentReceiptPurpose{{
+				Purpose:              terms.description,
+				PurposeCategory:      []string{terms.category},
+				ConsentType:          "EXPLICIT",
+				PiiCategory:          terms.piiCategory,
+				PrimaryPurpose:       true,
+				Termination:          termination,
+				Retention:            retention,
+				ThirdPartyDisclosure: terms.thirdParty != "",
+				ThirdPartyName:       terms.thirdParty,
+			}},
+		}},
+		Sensitive: consent.Purpose == purposeDataRetention,
+		SpiCat:    sensitiveCategories(consent.Purpose),
+		Consent: ConsentReceiptVersion{
+			ConsentID:     consent.ConsentID,
+			Action:        consent.Action,
+			Status:        consent.Status,
+			PolicyVersion: consent.PolicyVersion,
+			GrantedAt:     consent.GrantedAt,
+			ExpiresAt:     consent.ExpiresAt,
+			WithdrawnAt:   consent.WithdrawnAt,
+			Version:       consent.Version,
+			RecordHash:    consent.RecordHash,
+			TxID:          consent.TxID,
+		},
+	}
+}
+
+// sensitiveCategories lists the sensitive personal data a purpose covers
+func sensitiveCategories(purpose string) []string {
+	if purpose == purposeDataRetention {
+		return []string{"Biometric data", "Official identifiers"}
+	}
+	return []string{}
+}
+
+// issue builds the receipt for a consent version, stores it and anchors its hash. A receipt that
+// is already anchored is stored again and returned without a transaction.
+func (r *consentReceiptIssuer) issue(ctx context.Context, consent ConsentDocument, actor string) (*IssuedConsentReceipt, *TransactionResult, error) {
+	receipt := r.build(consent)
+	body, err := json.Marshal(receipt)
+	if err != nil {
+		return nil, nil, err
+	}
+	issued := &IssuedConsentReceipt{Receipt: receipt, Verified: true}
+	hash := consentReceiptHash(body)
+
+	anchor, err := ledger.GetConsentReceipt(ctx, receipt.ConsentReceiptID)
+	anchored := err == nil
+	switch {
+	case anchored && anchor.ReceiptHash != hash:
+		return nil, nil, fmt.Errorf("%s: %w", receipt.ConsentReceiptID, errConsentReceiptMismatch)
+	case !anchored && translateFabricError(err).Code != errCodeNotFound:
+		return nil, nil, err
+	}
+	// Receipts are rebuilt byte for byte, so a copy stored for a receipt whose anchoring failed is
+	// the copy the next attempt would store; it is left in place
+	if err := evidenceStore.Put(ctx, consentReceiptKey(consent.DigitalID, receipt.ConsentReceiptID), bytes.NewReader(body), int64(len(body)), "application/json"); err != nil {
+		return nil, nil, fmt.Errorf("failed to store consent receipt: %w", err)
+	}
+	if anchored {
+		issued.Anchor = anchor
+		return issued, nil, nil
+	}
+
+	result, err := submitTransaction(ctx, "RecordConsentReceipt", consent.ConsentID, strconv.Itoa(consent.Version), consent.RecordHash, hash, actor)
+	if err != nil {
+		return nil, nil, err
+	}
+	issued.Anchor = ConsentReceiptDocument{
+		DocType:           "consent_receipt",
+		ReceiptID:         receipt.ConsentReceiptID,
+		ConsentID:         consent.ConsentID,
+		DigitalID:         consent.DigitalID,
+		Purpose:           consent.Purpose,
+		Action:            consent.Action,
+		ConsentVersion:    consent.Version,
+		ConsentRecordHash: consent.RecordHash,
+		ReceiptHash:       hash,
+		IssuedBy:          actor,
+		IssuedAt:          time.Now().UTC().Format(time.RFC3339),
+		OwnerOrg:          consent.OwnerOrg,
+		TxID:              result.TxID,
+	}
+	return issued, result, nil
+}
+
+// read returns an issued receipt and verifies it. A receipt missing from storage is rebuilt from
+// its consent version when the rebuilt receipt still matches the anchor.
+func (r *consentReceiptIssuer) read(ctx context.Context, anchor ConsentReceiptDocument) (*IssuedConsentReceipt, error) {
+	history, err := ledger.ConsentHistory(ctx, anchor.DigitalID, anchor.Purpose)
+	if err != nil {
+		return nil, err
+	}
+	var described *ConsentDocument
+	for _, version := range history.Versions {
+		if version.Consent != nil && version.Consent.Version == anchor.ConsentVersion {
+			described = version.Consent
+		}
+	}
+
+	key := consentReceiptKey(anchor.DigitalID, anchor.ReceiptID)
+	body, err := readStoredReceipt(ctx, key)
+	if errors.Is(err, errObjectNotFound) && described != nil {
+		rebuilt, marshalErr := json.Marshal(r.build(*described))
+		if marshalErr != nil {
+			return nil, marshalErr
+		}
+		if consentReceiptHash(rebuilt) != anchor.ReceiptHash {
+			return nil, err
+		}
+		if err := evidenceStore.Put(ctx, key, bytes.NewReader(rebuilt), int64(len(rebuilt)), "application/json"); err != nil {
+			logWithContext(ctx, "Failed to store rebuilt consent receipt %s: %v", anchor.ReceiptID, err)
+		}
+		body, err = rebuilt, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	issued := &IssuedConsentReceipt{Anchor: anchor}
+	if err := json.Unmarshal(body, &issued.Receipt); err != nil {
+		return nil, fmt.Errorf("stored consent receipt %s is corrupt: %w", anchor.ReceiptID, err)
+	}
+	switch {
+	case consentReceiptHash(body) != anchor.ReceiptHash:
+		issued.Reason = "the receipt does not match the hash anchored on the ledger"
+	case described == nil || described.RecordHash != anchor.ConsentRecordHash:
+		issued.Reason = "the consent version the receipt describes is not on the ledger"
+	case !history.Intact:
+		issued.Reason = fmt.Sprintf("the consent's hash chain is broken at version %d", history.BrokenAt)
+	case issued.Receipt.Consent.RecordHash != anchor.ConsentRecordHash:
+		issued.Reason = "the receipt describes a different consent version than its anchor"
+	default:
+		issued.Verified = true
+	}
+	return issued, nil
+}
+
+func readStoredReceipt(ctx context.Context, key string) ([]byte, error) {
+	body, err := evidenceStore.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	var receipt bytes.Buffer
+	if _, err := receipt.ReadFrom(body); err != nil {
+		return nil, err
+	}
+	return receipt.Bytes(), nil
+}
+
+// issueReceipt issues the receipt for a committed consent change. The change stands if it fails.
+func issueReceipt(ctx context.Context, consent ConsentDocument) *IssuedConsentReceipt {
+	if consentReceipts == nil {
+		return nil
+	}
+	receipt, _, err := consentReceipts.issue(ctx, consent, consent.UpdatedBy)
+	if err != nil {
+		logWithContext(ctx, "Failed to issue the receipt for version %d of %s: %v", consent.Version, consent.ConsentID, err)
+		return nil
+	}
+	return receipt
+}
+
+// Consent receipt operations
+
+func (s ledgerService) GetConsentReceipt(ctx context.Context, receiptID string) (ConsentReceiptDocument, error) {
+	result, err := s.readDocument(ctx, "ReadConsentReceipt", receiptID)
+	if err != nil {
+		return ConsentReceiptDocument{}, err
+	}
+	return decodeDocument[ConsentReceiptDocument](result, "consent receipt")
+}
+
+// ListConsentReceipts returns a tourist's receipts, by consent and then version
+func (ledgerService) ListConsentReceipts(ctx context.Context, digitalID string, req ConsentReceiptListRequest) ([]ConsentReceiptDocument, error) {
+	var v fieldValidator
+	v.digitalID("id", digitalID)
+	errs := append(v.errors, req.Validate()...)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	result, err := evaluateTransaction(ctx, "GetConsentReceiptsByDID", digitalID)
+	if err != nil {
+		return nil, err
+	}
+	anchors, err := decodeDocument[[]ConsentReceiptDocument](result, "consent receipt")
+	if err != nil {
+		return nil, err
+	}
+	receipts := []ConsentReceiptDocument{}
+	for _, anchor := range anchors {
+		if req.Purpose == "" || anchor.Purpose == req.Purpose {
+			receipts = append(receipts, anchor)
+		}
+	}
+	sort.Slice(receipts, func(i, j int) bool {
+		if receipts[i].ConsentID != receipts[j].ConsentID {
+			return receipts[i].ConsentID < receipts[j].ConsentID
+		}
+		return receipts[i].ConsentVersion < receipts[j].ConsentVersion
+	})
+	return receipts, nil
+}
+
+// renderConsentReceiptPDF lays a receipt out on an A4 page, with the hash anchored for its JSON
+func renderConsentReceiptPDF(issued *IssuedConsentReceipt) []byte {
+	receipt := issued.Receipt
+	doc := newPDFDocument()
+	doc.Centered("Consent Receipt", 16, true)
+	doc.Centered(receipt.ConsentReceiptID, 10, false)
+	doc.Space(8)
+	doc.Field("Tourist", receipt.PiiPrincipalID, 10)
+	doc.Field("Recorded at", time.Unix(receipt.ConsentTimestamp, 0).UTC().Format(time.RFC3339), 10)
+	doc.Field("Jurisdiction", receipt.Jurisdiction, 10)
+	doc.Field("Collected through", receipt.CollectionMethod, 10)
+	doc.Field("Specification", receipt.Version, 10)
+
+	section := func(title string) {
+		doc.Space(6)
+		doc.Rule()
+		doc.Paragraph(title, 12, true)
+	}
+	section("Data controller")
+	for _, controller := range receipt.PiiControllers {
+		doc.Field("Controller", controller.PiiController, 10)
+		doc.Field("Contact", controller.Contact, 10)
+		for _, field := range [][2]string{{"Address", controller.Address}, {"Email", controller.Email}, {"Phone", controller.Phone}, {"Website", controller.PiiControllerURL}} {
+			if field[1] != "" {
+				doc.Field(field[0], field[1], 10)
+			}
+		}
+	}
+	if receipt.PolicyURL != "" {
+		doc.Field("Privacy policy", receipt.PolicyURL, 10)
+	}
+
+	section("What was agreed")
+	consent := receipt.Consent
+	doc.Field("Action", consent.Action, 10)
+	doc.Field("Status", consent.Status, 10)
+	doc.Field("Policy version", consent.PolicyVersion, 10)
+	doc.Field("Granted at", consent.GrantedAt, 10)
+	doc.Field("Expires at", consent.ExpiresAt, 10)
+	if consent.WithdrawnAt != "" {
+		doc.Field("Withdrawn at", consent.WithdrawnAt, 10)
+	}
+	for _, service := range receipt.Services {
+		for _, purpose := range service.Purposes {
+			doc.Field("Service", service.Service, 10)
+			doc.Field("Purpose", purpose.Purpose, 10)
+			doc.Field("Purpose category", strings.Join(purpose.PurposeCategory, ", "), 10)
+			doc.Field("Data categories", strings.Join(purpose.PiiCategory, ", "), 10)
+			doc.Field("Consent type", purpose.ConsentType, 10)
+			doc.Field("Termination", purpose.Termination, 10)
+			doc.Field("Retention", purpose.Retention, 10)
+			if purpose.ThirdPartyDisclosure {
+				doc.Field("Shared with", purpose.ThirdPartyName, 10)
+			}
+		}
+	}
+	if receipt.Sensitive {
+		doc.Field("Sensitive data", strings.Join(receipt.SpiCat, ", "), 10)
+	}
+
+	section("Ledger proof")
+	doc.Field("Consent version", strconv.Itoa(consent.Version), 10)
+	doc.Field("Consent record hash", consent.RecordHash, 10)
+	doc.Field("Consent transaction", consent.TxID, 10)
+	doc.Field("Receipt hash", issued.Anchor.ReceiptHash, 10)
+	doc.Field("Receipt transaction", issued.Anchor.TxID, 10)
+	verdict := "Verified against the ledger"
+	if !issued.Verified {
+		verdict = "Not verified: " + issued.Reason
+	}
+	doc.Field("Verification", verdict, 10)
+	doc.Space(6)
+	doc.Paragraph("The receipt hash is the SHA-256 of this receipt's JSON form, which can be downloaded from the same address without format=pdf.", 8, false)
+	return doc.Bytes()
+}
+
+func listConsentReceipts(c *gin.Context) {
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	var req ConsentReceiptListRequest
+	if !bindQuery(c, &req) {
+		return
+	}
+
+	receipts, err := ledger.ListConsentReceipts(c.Request.Context(), id, req)
+	if err != nil {
+		respondServiceError(c, "Failed to list consent receipts", err)
+		return
+	}
+
+	respondData(c, http.StatusOK, receipts)
+}
+
+// getConsentReceipt returns a receipt as JSON, or as a PDF with format=pdf, after verifying it
+// against its anchor
+func getConsentReceipt(c *gin.Context) {
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	receiptID, ok := validPathID(c, "receiptId")
+	if !ok {
+		return
+	}
+	var req ConsentReceiptRequest
+	if !bindQuery(c, &req) {
+		return
+	}
+
+	ctx := c.Request.Context()
+	anchor, err := ledger.GetConsentReceipt(ctx, receiptID)
+	if err == nil && anchor.DigitalID != id {
+		respondError(c, http.StatusNotFound, errCodeNotFound, "The receipt was not issued to "+id)
+		return
+	}
+	if err != nil {
+		respondServiceError(c, "Failed to get consent receipt", err)
+		return
+	}
+	issued, err := consentReceipts.read(ctx, anchor)
+	if errors.Is(err, errObjectNotFound) {
+		respondError(c, http.StatusNotFound, errCodeNotFound, "The receipt is no longer stored and cannot be rebuilt")
+		return
+	}
+	if err != nil {
+		respondServiceError(c, "Failed to read consent receipt", err)
+		return
+	}
+
+	if req.Format != "pdf" {
+		respondData(c, http.StatusOK, issued)
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.pdf"`, strings.NewReplacer(":", "_").Replace(receiptID)))
+	c.Header("X-Receipt-Hash", anchor.ReceiptHash)
+	c.Header("X-Receipt-Verified", strconv.FormatBool(issued.Verified))
+	c.Data(http.StatusOK, "application/pdf", renderConsentReceiptPDF(issued))
+}
+
+// issueConsentReceipt issues the receipt for a consent's current version, if it is not already
+func issueConsentReceipt(c *gin.Context) {
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	var req IssueConsentReceiptRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+	setAuditTarget(c, id)
+
+	ctx := c.Request.Context()
+	consent, err := ledger.GetConsent(ctx, id, req.Purpose)
+	if err != nil {
+		respondServiceError(c, "Failed to read consent", err)
+		return
+	}
+	issued, result, err := consentReceipts.issue(ctx, consent, req.Actor)
+	if err != nil {
+		if errors.Is(err, errConsentReceiptMismatch) {
+			respondError(c, http.StatusConflict, errCodeReceiptMismatch, err.Error())
+			return
+		}
+		respondServiceError(c, "Failed to issue consent receipt", err)
+		return
+	}
+
+	respondCommitted(c, http.StatusOK, issued, result)
+}
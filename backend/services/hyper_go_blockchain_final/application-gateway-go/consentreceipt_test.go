@@ -0,0 +1,91 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConsentReceipt(t *testing.T) {
+	issuer := &consentReceiptIssuer{
+		controller:   ConsentController{PiiController: "Meghalaya Tourism Safety Cell", Contact: "Data Protection Officer", Email: "dpo@example.gov.in"},
+		policyURL:    "https://example.gov.in/privacy/v3",
+		jurisdiction: "IN",
+		language:     "en",
+		method:       "Tourist safety app",
+	}
+	did := "did:sih:tourist1"
+	withdraw := ConsentDocument{
+		ConsentID: consentID(did, purposeFamilySharing), DigitalID: did, Purpose: purposeFamilySharing,
+		Action: "withdraw", Status: "withdrawn", PolicyVersion: "v3", GrantedAt: "2025-01-01T10:00:00Z",
+		ExpiresAt: "2025-07-01T00:00:00Z", WithdrawnAt: "2025-03-01T10:00:00Z", Version: 2,
+		PreviousHash: strings.Repeat("a", 64), UpdatedBy: "tourist_app", UpdatedAt: "2025-03-01T10:00:00Z", TxID: "tx-2",
+	}
+	withdraw.RecordHash = consentRecordHash(withdraw)
+
+	receipt := issuer.build(withdraw)
+	if receipt.ConsentReceiptID != "CR:CONSENT:did:sih:tourist1:family_sharing:v2" || receipt.PiiPrincipalID != did || receipt.ConsentTimestamp != time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC).Unix() {
+		t.Errorf("unexpected receipt header %+v", receipt)
+	}
+	purpose := receipt.Services[0].Purposes[0]
+	if !purpose.ThirdPartyDisclosure || !strings.Contains(purpose.Termination, "Withdrawn at 2025-03-01T10:00:00Z") || len(purpose.PiiCategory) == 0 {
+		t.Errorf("expected the withdrawal, data categories and recipients on the receipt, got %+v", purpose)
+	}
+	if receipt.Consent.RecordHash != withdraw.RecordHash || receipt.Consent.Version != 2 || receipt.Sensitive {
+		t.Errorf("expected the receipt to name the consent version, got %+v", receipt.Consent)
+	}
+
+	// A receipt is rebuilt byte for byte from the same version, so its anchored hash can be checked
+	first, _ := json.Marshal(receipt)
+	second, _ := json.Marshal(issuer.build(withdraw))
+	if consentReceiptHash(first) != consentReceiptHash(second) {
+		t.Error("expected the receipt rebuilt identically")
+	}
+	renewed := withdraw
+	renewed.ExpiresAt = "2030-01-01T00:00:00Z"
+	if changed, _ := json.Marshal(issuer.build(renewed)); consentReceiptHash(changed) == consentReceiptHash(first) {
+		t.Error("expected a different consent version to give a different receipt")
+	}
+
+	previous := eraser
+	eraser = &erasureService{window: 720 * time.Hour}
+	defer func() { eraser = previous }()
+	retention := withdraw
+	retention.Purpose, retention.ConsentID = purposeDataRetention, consentID(did, purposeDataRetention)
+	sensitive := issuer.build(retention)
+	if !sensitive.Sensitive || len(sensitive.SpiCat) == 0 || !strings.Contains(sensitive.Services[0].Purposes[0].Retention, "720h0m0s after the Digital ID expires") {
+		t.Errorf("expected KYC artifacts marked sensitive with the retention window, got %+v", sensitive)
+	}
+
+	issued := &IssuedConsentReceipt{Receipt: receipt, Anchor: ConsentReceiptDocument{ReceiptHash: consentReceiptHash(first), TxID: "tx-3"}, Verified: true}
+	pdf := renderConsentReceiptPDF(issued)
+	if !bytes.HasPrefix(pdf, []byte("%PDF-")) || !bytes.Contains(pdf, []byte(issued.Anchor.ReceiptHash)) {
+		t.Error("expected a PDF carrying the anchored receipt hash")
+	}
+}
+
+func TestConsentReceiptRequests(t *testing.T) {
+	if errs := (ConsentReceiptListRequest{Purpose: "marketing"}).Validate(); len(errs) != 1 {
+		t.Errorf("expected an unknown purpose refused, got %v", errs)
+	}
+	if errs := (ConsentReceiptRequest{Format: "docx"}).Validate(); len(errs) != 1 {
+		t.Errorf("expected an unknown format refused, got %v", errs)
+	}
+	if errs := (IssueConsentReceiptRequest{Purpose: purposeLocationTracking, Actor: "tourist_app"}).Validate(); len(errs) != 0 {
+		t.Errorf("unexpected errors %v", errs)
+	}
+
+	// The consent change response keeps the consent's fields at the top level
+	schemas := openAPISpec()["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+	properties := schemas["ConsentChange"].(map[string]interface{})["properties"].(map[string]interface{})
+	for _, name := range []string{"consent_id", "record_hash", "receipt"} {
+		if _, ok := properties[name]; !ok {
+			t.Errorf("expected %s in the ConsentChange schema, got %v", name, properties)
+		}
+	}
+	if _, ok := properties["ConsentDocument"]; ok {
+		t.Error("expected the embedded consent flattened")
+	}
+}
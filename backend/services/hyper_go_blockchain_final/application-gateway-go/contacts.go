@@ -0,0 +1,819 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/mail"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	contactsKeyPrefix    = "sih:contacts:"
+	contactCodeKeyPrefix = "sih:contact-code:"
+	maxContactName       = 100
+	maxContactRelation   = 50
+	maxContactsLimit     = 20
+
+	contactChannelSMS   = "sms"
+	contactChannelEmail = "email"
+
+	errCodeContactsDisabled   = "CONTACTS_DISABLED"
+	errCodeContactChannel     = "CONTACT_CHANNEL_UNAVAILABLE"
+	errCodeContactLimit       = "CONTACT_LIMIT_REACHED"
+	errCodeVerificationFailed = "VERIFICATION_CODE_INVALID"
+	errCodeVerificationNone   = "VERIFICATION_CODE_EXPIRED"
+)
+
+var (
+	contactChannels        = []string{contactChannelSMS, contactChannelEmail}
+	verificationCodeRegex  = regexp.MustCompile(`^[0-9]{6}$`)
+	errContactNotFound     = errors.New("contact not found")
+	errContactLimit        = errors.New("contact limit reached")
+	errContactCodeNone     = errors.New("no verification code is pending")
+	errContactCodeWrong    = errors.New("verification code does not match")
+	errContactCodeTooSoon  = errors.New("verification code sent too recently")
+	errContactChannelUnset = errors.New("channel not configured")
+)
+
+// ContactRequest registers or replaces an emergency contact. Channels lists how the contact wants
+// to be notified; it defaults to every address given. Priority 1 is notified first; without one a
+// new contact goes last and an updated one keeps its place.
+type ContactRequest struct {
+	Name     string   `json:"name" binding:"required"`
+	Relation string   `json:"relation"`
+	Phone    string   `json:"phone"`
+	Email    string   `json:"email"`
+	Priority int      `json:"priority"`
+	Channels []string `json:"channels"`
+	Actor    string   `json:"actor" binding:"required"`
+}
+
+func (r ContactRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	if len(r.Name) > maxContactName {
+		v.add("name", "must be at most %d characters", maxContactName)
+	}
+	if len(r.Relation) > maxContactRelation {
+		v.add("relation", "must be at most %d characters", maxContactRelation)
+	}
+	if r.Phone == "" && r.Email == "" {
+		v.add("phone", "is required when email is not given")
+	}
+	if r.Phone != "" {
+		if _, err := normalizeMobile(r.Phone, "+91"); err != nil {
+			v.add("phone", "must be a mobile number, in E.164 form or national form")
+		}
+	}
+	if r.Email != "" {
+		if address, err := mail.ParseAddress(r.Email); err != nil || address.Address != r.Email {
+			v.add("email", "must be an email address")
+		}
+	}
+	if r.Priority < 0 || r.Priority > maxContactsLimit {
+		v.add("priority", "must be between 1 and %d", maxContactsLimit)
+	}
+	for i, channel := range r.Channels {
+		field := fmt.Sprintf("channels[%d]", i)
+		v.oneOf(field, channel, contactChannels)
+		if channel == contactChannelSMS && r.Phone == "" || channel == contactChannelEmail && r.Email == "" {
+			v.add(field, "needs the contact's %s", map[string]string{contactChannelSMS: "phone", contactChannelEmail: "email"}[channel])
+		}
+	}
+	v.identifier("actor", r.Actor)
+	return v.errors
+}
+
+// ContactCodeRequest asks for a verification code on one channel
+type ContactCodeRequest struct {
+	Channel string `json:"channel" binding:"required"`
+}
+
+func (r ContactCodeRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.oneOf("channel", r.Channel, contactChannels)
+	return v.errors
+}
+
+// VerifyContactRequest carries the code a contact received
+type VerifyContactRequest struct {
+	Channel string `json:"channel" binding:"required"`
+	Code    string `json:"code" binding:"required"`
+}
+
+func (r VerifyContactRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.oneOf("channel", r.Channel, contactChannels)
+	if !verificationCodeRegex.MatchString(r.Code) {
+		v.add("code", "must be the 6 digit code sent to the contact")
+	}
+	return v.errors
+}
+
+// RegisteredContact is an emergency contact as the registry stores it. Contacts stay off the
+// ledger. Each address is notified only once the contact has proved they hold it.
+type RegisteredContact struct {
+	ContactID     string   `json:"contact_id"`
+	DigitalID     string   `json:"digital_id"`
+	Name          string   `json:"name"`
+	Relation      string   `json:"relation,omitempty"`
+	Phone         string   `json:"phone,omitempty"`
+	Email         string   `json:"email,omitempty"`
+	Priority      int      `json:"priority"`
+	Channels      []string `json:"channels"`
+	PhoneVerified bool     `json:"phone_verified"`
+	EmailVerified bool     `json:"email_verified"`
+	CreatedBy     string   `json:"created_by"`
+	CreatedAt     string   `json:"created_at"`
+	UpdatedAt     string   `json:"updated_at"`
+}
+
+// ContactRegistration is a saved contact and the channels a verification code was sent on
+type ContactRegistration struct {
+	Contact   RegisteredContact `json:"contact"`
+	CodesSent []string          `json:"codes_sent"`
+}
+
+func (c RegisteredContact) address(channel string) string {
+	if channel == contactChannelSMS {
+		return c.Phone
+	}
+	return c.Email
+}
+
+func (c RegisteredContact) verified(channel string) bool {
+	if channel == contactChannelSMS {
+		return c.PhoneVerified
+	}
+	return c.EmailVerified
+}
+
+// emergencyContact keeps the verified addresses of the channels the contact chose, or reports false
+// when none is left
+func (c RegisteredContact) emergencyContact() (EmergencyContact, bool) {
+	contact := EmergencyContact{Name: c.Name, Relation: c.Relation}
+	if slices.Contains(c.Channels, contactChannelSMS) && c.PhoneVerified {
+		contact.Phone = c.Phone
+	}
+	if slices.Contains(c.Channels, contactChannelEmail) && c.EmailVerified {
+		contact.Email = c.Email
+	}
+	return contact, contact.Phone != "" || contact.Email != ""
+}
+
+// contactCode is a pending verification code. Only its hash is kept.
+type contactCode struct {
+	CodeHash  string `json:"code_hash"`
+	Attempts  int    `json:"attempts"`
+	SentAt    string `json:"sent_at"`
+	ExpiresAt string `json:"expires_at"`
+}
+
+func contactCodeKey(digitalID, contactID, channel string) string {
+	return contactCodeKeyPrefix + digitalID + ":" + contactID + ":" + channel
+}
+
+// contactStore keeps each tourist's contacts and the pending verification codes
+type contactStore interface {
+	// list returns the tourist's contacts by priority
+	list(ctx context.Context, digitalID string) ([]RegisteredContact, error)
+	put(ctx context.Context, contact RegisteredContact) error
+	remove(ctx context.Context, digitalID, contactID string) error
+	code(ctx context.Context, key string) (*contactCode, error)
+	setCode(ctx context.Context, key string, code contactCode, ttl time.Duration) error
+	clearCode(ctx context.Context, key string) error
+}
+
+func sortContacts(contacts []RegisteredContact) {
+	sort.SliceStable(contacts, func(i, j int) bool {
+		if contacts[i].Priority != contacts[j].Priority {
+			return contacts[i].Priority < contacts[j].Priority
+		}
+		return contacts[i].CreatedAt < contacts[j].CreatedAt
+	})
+}
+
+// contactRegistry is the contact directory tourists maintain themselves. Tourists without a
+// verified contact fall back to EMERGENCY_CONTACTS_URL or EMERGENCY_CONTACTS_FILE, if set.
+type contactRegistry struct {
+	store       contactStore
+	fallback    contactDirectory
+	maxContacts int
+	codeTTL     time.Duration
+	maxAttempts int
+	resendAfter time.Duration
+	countryCode string
+}
+
+var contactsRegistry *contactRegistry
+
+// initContacts runs after initSOS, initSMS and initEmail, and before initConsent so consent
+// enforcement also covers registered contacts
+func initContacts() {
+	if !getEnvBool("EMERGENCY_CONTACTS_REGISTRY", false) {
+		log.Println("📇 EMERGENCY_CONTACTS_REGISTRY not set, emergency contacts come from the configured directory")
+		return
+	}
+	maxContacts := getEnvInt("EMERGENCY_CONTACTS_MAX", 5)
+	if maxContacts < 1 || maxContacts > maxContactsLimit {
+		panic(fmt.Errorf("EMERGENCY_CONTACTS_MAX must be between 1 and %d", maxContactsLimit))
+	}
+	var store contactStore = newMemoryContactStore()
+	if documentCache != nil {
+		store = redisContactStore{documentCache}
+	}
+	contactsRegistry = &contactRegistry{
+		store:       store,
+		fallback:    emergencyContacts,
+		maxContacts: maxContacts,
+		codeTTL:     getEnvDuration("CONTACT_CODE_TTL", 10*time.Minute),
+		maxAttempts: getEnvInt("CONTACT_CODE_ATTEMPTS", 5),
+		resendAfter: getEnvDuration("CONTACT_CODE_RESEND_INTERVAL", time.Minute),
+		countryCode: getEnv("SMS_DEFAULT_COUNTRY_CODE", "+91"),
+	}
+	emergencyContacts = contactsRegistry
+	log.Printf("📇 Tourists register up to %d emergency contacts, verified by code", maxContacts)
+}
+
+// contacts lists the tourist's verified contacts by priority, for SOS notification, escalation
+// chains and missing person alerts
+func (r *contactRegistry) contacts(ctx context.Context, digitalID string) ([]EmergencyContact, error) {
+	registered, err := r.store.list(ctx, digitalID)
+	if err != nil {
+		return nil, err
+	}
+	var contacts []EmergencyContact
+	for _, c := range registered {
+		if contact, ok := c.emergencyContact(); ok {
+			contacts = append(contacts, contact)
+		}
+	}
+	if len(contacts) == 0 && r.fallback != nil {
+		return r.fallback.contacts(ctx, digitalID)
+	}
+	return contacts, nil
+}
+
+func (r *contactRegistry) find(ctx context.Context, digitalID, contactID string) (*RegisteredContact, []RegisteredContact, error) {
+	contacts, err := r.store.list(ctx, digitalID)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range contacts {
+		if contacts[i].ContactID == contactID {
+			return &contacts[i], contacts, nil
+		}
+	}
+	return nil, contacts, errContactNotFound
+}
+
+// arrange places contact at its priority among the others, or last without one, and saves every
+// contact whose priority changed so priorities run from 1 without gaps
+func (r *contactRegistry) arrange(ctx context.Context, others []RegisteredContact, contact RegisteredContact) (RegisteredContact, error) {
+	var ordered []RegisteredContact
+	for _, other := range others {
+		if other.ContactID != contact.ContactID {
+			ordered = append(ordered, other)
+		}
+	}
+	at := len(ordered)
+	if contact.Priority > 0 && contact.Priority-1 < at {
+		at = contact.Priority - 1
+	}
+	ordered = slices.Insert(ordered, at, contact)
+	for i := range ordered {
+		if ordered[i].ContactID == contact.ContactID {
+			ordered[i].Priority = i + 1
+			contact = ordered[i]
+			if err := r.store.put(ctx, contact); err != nil {
+				return contact, err
+			}
+			continue
+		}
+		if ordered[i].Priority != i+1 {
+			ordered[i].Priority = i + 1
+			if err := r.store.put(ctx, ordered[i]); err != nil {
+				return contact, err
+			}
+		}
+	}
+	return contact, nil
+}
+
+// create registers a contact and sends a verification code to each address whose channel is
+// configured. A failed send only leaves the address unverified; the code can be requested again.
+func (r *contactRegistry) create(ctx context.Context, digitalID string, req ContactRequest) (ContactRegistration, error) {
+	contacts, err := r.store.list(ctx, digitalID)
+	if err != nil {
+		return ContactRegistration{}, err
+	}
+	if len(contacts) >= r.maxContacts {
+		return ContactRegistration{}, errContactLimit
+	}
+	suffix := make([]byte, 6)
+	rand.Read(suffix)
+	now := time.Now().UTC().Format(time.RFC3339)
+	contact := RegisteredContact{
+		ContactID: "EC-" + hex.EncodeToString(suffix),
+		DigitalID: digitalID,
+		CreatedBy: req.Actor,
+		CreatedAt: now,
+	}
+	r.apply(&contact, req)
+	if contact, err = r.arrange(ctx, contacts, contact); err != nil {
+		return ContactRegistration{}, err
+	}
+	return ContactRegistration{Contact: contact, CodesSent: r.sendCodes(ctx, contact, contactChannels)}, nil
+}
+
+// update replaces a contact's details. A changed address must be verified again.
+func (r *contactRegistry) update(ctx context.Context, digitalID, contactID string, req ContactRequest) (ContactRegistration, error) {
+	existing, contacts, err := r.find(ctx, digitalID, contactID)
+	if err != nil {
+		return ContactRegistration{}, err
+	}
+	contact := *existing
+	if req.Priority == 0 {
+		req.Priority = existing.Priority
+	}
+	r.apply(&contact, req)
+
+	var changed []string
+	if contact.Phone != existing.Phone {
+		contact.PhoneVerified = false
+		changed = append(changed, contactChannelSMS)
+	}
+	if contact.Email != existing.Email {
+		contact.EmailVerified = false
+		changed = append(changed, contactChannelEmail)
+	}
+	for _, channel := range changed {
+		if err := r.store.clearCode(ctx, contactCodeKey(digitalID, contactID, channel)); err != nil {
+			return ContactRegistration{}, err
+		}
+	}
+	if contact, err = r.arrange(ctx, contacts, contact); err != nil {
+		return ContactRegistration{}, err
+	}
+	return ContactRegistration{Contact: contact, CodesSent: r.sendCodes(ctx, contact, changed)}, nil
+}
+
+func (r *contactRegistry) apply(contact *RegisteredContact, req ContactRequest) {
+	contact.Name, contact.Relation, contact.Email, contact.Priority = req.Name, req.Relation, req.Email, req.Priority
+	contact.Phone = ""
+	if req.Phone != "" {
+		contact.Phone, _ = normalizeMobile(req.Phone, r.countryCode)
+	}
+	contact.Channels = nil
+	for _, channel := range contactChannels {
+		if (len(req.Channels) == 0 || slices.Contains(req.Channels, channel)) && contact.address(channel) != "" {
+			contact.Channels = append(contact.Channels, channel)
+		}
+	}
+	contact.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+}
+
+// remove deletes a contact and closes the gap in priorities
+func (r *contactRegistry) remove(ctx context.Context, digitalID, contactID string) error {
+	_, contacts, err := r.find(ctx, digitalID, contactID)
+	if err != nil {
+		return err
+	}
+	if err := r.store.remove(ctx, digitalID, contactID); err != nil {
+		return err
+	}
+	for _, channel := range contactChannels {
+		r.store.clearCode(ctx, contactCodeKey(digitalID, contactID, channel))
+	}
+	priority := 0
+	for _, other := range contacts {
+		if other.ContactID == contactID {
+			continue
+		}
+		if priority++; other.Priority != priority {
+			other.Priority = priority
+			if err := r.store.put(ctx, other); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (r *contactRegistry) sendCodes(ctx context.Context, contact RegisteredContact, channels []string) []string {
+	sent := []string{}
+	for _, channel := range channels {
+		if contact.address(channel) == "" || !contactChannelConfigured(channel) {
+			continue
+		}
+		if err := r.sendCode(ctx, contact, channel); err != nil {
+			logWithContext(ctx, "Failed to send a verification code to contact %s by %s: %v", contact.ContactID, channel, err)
+			continue
+		}
+		sent = append(sent, channel)
+	}
+	return sent
+}
+
+func contactChannelConfigured(channel string) bool {
+	if channel == contactChannelSMS {
+		return smsGateway != nil
+	}
+	return emailer != nil
+}
+
+// sendCode sends a new code, replacing any pending one. Codes may be resent once every
+// CONTACT_CODE_RESEND_INTERVAL.
+func (r *contactRegistry) sendCode(ctx context.Context, contact RegisteredContact, channel string) error {
+	if !contactChannelConfigured(channel) {
+		return errContactChannelUnset
+	}
+	key := contactCodeKey(contact.DigitalID, contact.ContactID, channel)
+	pending, err := r.store.code(ctx, key)
+	if err != nil {
+		return err
+	}
+	if pending != nil {
+		sentAt, _ := time.Parse(time.RFC3339, pending.SentAt)
+		if time.Since(sentAt) < r.resendAfter {
+			return errContactCodeTooSoon
+		}
+	}
+
+	n, err := rand.Int(rand.Reader, big.NewInt(1_000_000))
+	if err != nil {
+		return err
+	}
+	code := fmt.Sprintf("%06d", n.Int64())
+	now := time.Now().UTC()
+	if err := r.store.setCode(ctx, key, contactCode{
+		CodeHash:  hashToken(key + ":" + code),
+		SentAt:    now.Format(time.RFC3339),
+		ExpiresAt: now.Add(r.codeTTL).Format(time.RFC3339),
+	}, r.codeTTL); err != nil {
+		return err
+	}
+
+	if channel == contactChannelSMS {
+		_, err = smsGateway.send(ctx, "contact_verification", contact.Phone, contact.ContactID, smsData{RecipientName: contact.Name, DigitalID: contact.DigitalID, Code: code})
+		return err
+	}
+	message, err := emailer.render("contact_verification", emailData{RecipientName: contact.Name, DigitalID: contact.DigitalID, Code: code})
+	if err != nil {
+		return err
+	}
+	message.to = contact.Email
+	return emailer.mailer.send(ctx, message)
+}
+
+// verify checks a code. A pending code is dropped after CONTACT_CODE_ATTEMPTS wrong guesses.
+func (r *contactRegistry) verify(ctx context.Context, digitalID, contactID string, req VerifyContactRequest) (RegisteredContact, error) {
+	existing, _, err := r.find(ctx, digitalID, contactID)
+	if err != nil {
+		return RegisteredContact{}, err
+	}
+	contact := *existing
+	key := contactCodeKey(digitalID, contactID, req.Channel)
+	pending, err := r.store.code(ctx, key)
+	if err != nil {
+		return contact, err
+	}
+	expires := time.Time{}
+	if pending != nil {
+		expires, _ = time.Parse(time.RFC3339, pending.ExpiresAt)
+	}
+	if pending == nil || !time.Now().Before(expires) {
+		return contact, errContactCodeNone
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashToken(key+":"+req.Code)), []byte(pending.CodeHash)) != 1 {
+		pending.Attempts++
+		if pending.Attempts >= r.maxAttempts {
+			err = r.store.clearCode(ctx, key)
+		} else {
+			err = r.store.setCode(ctx, key, *pending, time.Until(expires))
+		}
+		return contact, errors.Join(errContactCodeWrong, err)
+	}
+
+	if err := r.store.clearCode(ctx, key); err != nil {
+		return contact, err
+	}
+	if req.Channel == contactChannelSMS {
+		contact.PhoneVerified = true
+	} else {
+		contact.EmailVerified = true
+	}
+	contact.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	return contact, r.store.put(ctx, contact)
+}
+
+type redisContactStore struct {
+	redis *redisClient
+}
+
+func (s redisContactStore) list(ctx context.Context, digitalID string) ([]RegisteredContact, error) {
+	reply, err := s.redis.Do(ctx, "HVALS", contactsKeyPrefix+digitalID)
+	if err != nil {
+		return nil, err
+	}
+	values, _ := reply.([]interface{})
+	contacts := make([]RegisteredContact, 0, len(values))
+	for _, value := range values {
+		raw, _ := value.([]byte)
+		var contact RegisteredContact
+		if json.Unmarshal(raw, &contact) == nil {
+			contacts = append(contacts, contact)
+		}
+	}
+	sortContacts(contacts)
+	return contacts, nil
+}
+
+func (s redisContactStore) put(ctx context.Context, contact RegisteredContact) error {
+	data, err := json.Marshal(contact)
+	if err != nil {
+		return err
+	}
+	_, err = s.redis.Do(ctx, "HSET", contactsKeyPrefix+contact.DigitalID, contact.ContactID, string(data))
+	return err
+}
+
+func (s redisContactStore) remove(ctx context.Context, digitalID, contactID string) error {
+	_, err := s.redis.Do(ctx, "HDEL", contactsKeyPrefix+digitalID, contactID)
+	return err
+}
+
+func (s redisContactStore) code(ctx context.Context, key string) (*contactCode, error) {
+	data, err := s.redis.Get(ctx, key)
+	if errors.Is(err, errRedisNil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var code contactCode
+	if err := json.Unmarshal(data, &code); err != nil {
+		return nil, err
+	}
+	return &code, nil
+}
+
+func (s redisContactStore) setCode(ctx context.Context, key string, code contactCode, ttl time.Duration) error {
+	data, err := json.Marshal(code)
+	if err != nil {
+		return err
+	}
+	return s.redis.Set(ctx, key, data, ttl)
+}
+
+func (s redisContactStore) clearCode(ctx context.Context, key string) error {
+	return s.redis.Del(ctx, key)
+}
+
+// memoryContactStore serves a single gateway instance
+type memoryContactStore struct {
+	mu       sync.Mutex
+	contacts map[string]map[string]RegisteredContact
+	codes    map[string]contactCode
+	expires  map[string]time.Time
+}
+
+func newMemoryContactStore() *memoryContactStore {
+	return &memoryContactStore{contacts: map[string]map[string]RegisteredContact{}, codes: map[string]contactCode{}, expires: map[string]time.Time{}}
+}
+
+func (s *memoryContactStore) list(_ context.Context, digitalID string) ([]RegisteredContact, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	contacts := make([]RegisteredContact, 0, len(s.contacts[digitalID]))
+	for _, contact := range s.contacts[digitalID] {
+		contacts = append(contacts, contact)
+	}
+	sortContacts(contacts)
+	return contacts, nil
+}
+
+func (s *memoryContactStore) put(_ context.Context, contact RegisteredContact) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.contacts[contact.DigitalID] == nil {
+		s.contacts[contact.DigitalID] = map[string]RegisteredContact{}
+	}
+	s.contacts[contact.DigitalID][contact.ContactID] = contact
+	return nil
+}
+
+func (s *memoryContactStore) remove(_ context.Context, digitalID, contactID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.contacts[digitalID], contactID)
+	return nil
+}
+
+func (s *memoryContactStore) code(_ context.Context, key string) (*contactCode, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	code, ok := s.codes[key]
+	if !ok || time.Now().After(s.expires[key]) {
+		return nil, nil
+	}
+	return &code, nil
+}
+
+func (s *memoryContactStore) setCode(_ context.Context, key string, code contactCode, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.codes[key], s.expires[key] = code, time.Now().Add(ttl)
+	return nil
+}
+
+func (s *memoryContactStore) clearCode(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.codes, key)
+	delete(s.expires, key)
+	return nil
+}
+
+// respondContactError maps registry errors onto responses
+func respondContactError(c *gin.Context, message string, err error) {
+	switch {
+	case errors.Is(err, errContactNotFound):
+		respondError(c, http.StatusNotFound, errCodeNotFound, "No such contact for this tourist")
+	case errors.Is(err, errContactLimit):
+		respondError(c, http.StatusConflict, errCodeContactLimit, fmt.Sprintf("A tourist may register at most %d emergency contacts", contactsRegistry.maxContacts))
+	case errors.Is(err, errContactChannelUnset):
+		respondError(c, http.StatusServiceUnavailable, errCodeContactChannel, "Verification codes cannot be sent on this channel")
+	case errors.Is(err, errContactCodeTooSoon):
+		c.Header("Retry-After", strconv.Itoa(int(contactsRegistry.resendAfter.Seconds())))
+		respondError(c, http.StatusTooManyRequests, errCodeRateLimited, "A code was sent recently; wait before requesting another")
+	case errors.Is(err, errContactCodeNone):
+		respondError(c, http.StatusBadRequest, errCodeVerificationNone, "No verification code is pending on this channel; request a new one")
+	case errors.Is(err, errContactCodeWrong):
+		respondError(c, http.StatusBadRequest, errCodeVerificationFailed, "The verification code does not match")
+	default:
+		respondServiceError(c, message, err)
+	}
+}
+
+func contactsTourist(c *gin.Context) (string, bool) {
+	if contactsRegistry == nil {
+		respondError(c, http.StatusServiceUnavailable, errCodeContactsDisabled, "The emergency contact registry is not enabled")
+		return "", false
+	}
+	digitalID := c.Param("digitalId")
+	var v fieldValidator
+	if v.digitalID("digitalId", digitalID); len(v.errors) > 0 {
+		respondValidationErrors(c, v.errors)
+		return "", false
+	}
+	setAuditTarget(c, digitalID)
+	return digitalID, true
+}
+
+func createContact(c *gin.Context) {
+	digitalID, ok := contactsTourist(c)
+	if !ok {
+		return
+	}
+	var req ContactRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+	registration, err := contactsRegistry.create(c.Request.Context(), digitalID, req)
+	if err != nil {
+		respondContactError(c, "Failed to register contact", err)
+		return
+	}
+	respondData(c, http.StatusCreated, registration)
+}
+
+func listContacts(c *gin.Context) {
+	digitalID, ok := contactsTourist(c)
+	if !ok {
+		return
+	}
+	contacts, err := contactsRegistry.store.list(c.Request.Context(), digitalID)
+	if err != nil {
+		respondServiceError(c, "Failed to list contacts", err)
+		return
+	}
+	respondData(c, http.StatusOK, contacts)
+}
+
+func updateContact(c *gin.Context) {
+	digitalID, ok := contactsTourist(c)
+	if !ok {
+		return
+	}
+	contactID, ok := validPathID(c, "contactId")
+	if !ok {
+		return
+	}
+	var req ContactRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+	registration, err := contactsRegistry.update(c.Request.Context(), digitalID, contactID, req)
+	if err != nil {
+		respondContactError(c, "Failed to update contact", err)
+		return
+	}
+	respondData(c, http.StatusOK, registration)
+}
+
+func deleteContact(c *gin.Context) {
+	digitalID, ok := contactsTourist(c)
+	if !ok {
+		return
+	}
+	contactID, ok := validPathID(c, "contactId")
+	if !ok {
+		return
+	}
+	var req DeleteRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+	if err := contactsRegistry.remove(c.Request.Context(), digitalID, contactID); err != nil {
+		respondContactError(c, "Failed to remove contact", err)
+		return
+	}
+	respondData(c, http.StatusOK, gin.H{"contactID": contactID, "digitalID": digitalID})
+}
+
+// sendContactCode sends a new verification code to one of the contact's addresses
+func sendContactCode(c *gin.Context) {
+	digitalID, ok := contactsTourist(c)
+	if !ok {
+		return
+	}
+	contactID, ok := validPathID(c, "contactId")
+	if !ok {
+		return
+	}
+	var req ContactCodeRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+	contact, _, err := contactsRegistry.find(c.Request.Context(), digitalID, contactID)
+	if err != nil {
+		respondContactError(c, "Failed to send verification code", err)
+		return
+	}
+	if contact.address(req.Channel) == "" {
+		respondValidationErrors(c, ValidationErrors{{Field: "channel", Message: "has no address for this contact"}})
+		return
+	}
+	if contact.verified(req.Channel) {
+		respondData(c, http.StatusOK, ContactRegistration{Contact: *contact, CodesSent: []string{}})
+		return
+	}
+	if err := contactsRegistry.sendCode(c.Request.Context(), *contact, req.Channel); err != nil {
+		respondContactError(c, "Failed to send verification code", err)
+		return
+	}
+	respondData(c, http.StatusOK, ContactRegistration{Contact: *contact, CodesSent: []string{req.Channel}})
+}
+
+func verifyContact(c *gin.Context) {
+	digitalID, ok := contactsTourist(c)
+	if !ok {
+		return
+	}
+	contactID, ok := validPathID(c, "contactId")
+	if !ok {
+		return
+	}
+	var req VerifyContactRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+	contact, err := contactsRegistry.verify(c.Request.Context(), digitalID, contactID, req)
+	if err != nil {
+		respondContactError(c, "Failed to verify contact", err)
+		return
+	}
+	respondData(c, http.StatusOK, contact)
+}
@@ -0,0 +1,178 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func testContactRegistry(t *testing.T) (*contactRegistry, *recordingMailer) {
+	templates, err := loadEmailTemplates("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mailer := &recordingMailer{}
+	emailer = &emailNotifier{mailer: mailer, templates: templates}
+	t.Cleanup(func() { emailer = nil })
+	return &contactRegistry{
+		store:       newMemoryContactStore(),
+		fallback:    fileContactDirectory{"did:sih:tourist_1": {{Name: "Hotel desk", Phone: "+911111111111"}}},
+		maxContacts: 3,
+		codeTTL:     time.Minute,
+		maxAttempts: 2,
+		countryCode: "+91",
+	}, mailer
+}
+
+func sentCode(t *testing.T, mailer *recordingMailer) string {
+	t.Helper()
+	if len(mailer.sent) == 0 {
+		t.Fatal("expected a verification email")
+	}
+	code := regexp.MustCompile(`\b[0-9]{6}\b`).FindString(mailer.sent[len(mailer.sent)-1].body)
+	if code == "" {
+		t.Fatalf("expected a code in %q", mailer.sent[len(mailer.sent)-1].body)
+	}
+	return code
+}
+
+func TestContactRequestValidate(t *testing.T) {
+	valid := ContactRequest{Name: "Asha", Phone: "098765 43210", Email: "asha@example.com", Channels: []string{"sms"}, Actor: "tourist_app"}
+	if errs := valid.Validate(); len(errs) > 0 {
+		t.Fatalf("expected a valid request, got %v", errs)
+	}
+	for name, tt := range map[string]struct {
+		mutate func(*ContactRequest)
+		field  string
+	}{
+		"no address":    {func(r *ContactRequest) { r.Phone, r.Email, r.Channels = "", "", nil }, "phone"},
+		"bad phone":     {func(r *ContactRequest) { r.Phone = "call me" }, "phone"},
+		"bad email":     {func(r *ContactRequest) { r.Email = "Asha <asha@example.com>" }, "email"},
+		"channel":       {func(r *ContactRequest) { r.Channels = []string{"pager"} }, "channels[0]"},
+		"channel phone": {func(r *ContactRequest) { r.Phone = "" }, "channels[0]"},
+	} {
+		req := valid
+		tt.mutate(&req)
+		found := false
+		for _, err := range req.Validate() {
+			found = found || err.Field == tt.field
+		}
+		if !found {
+			t.Errorf("%s: expected an error on %s", name, tt.field)
+		}
+	}
+}
+
+func TestContactVerification(t *testing.T) {
+	ctx := context.Background()
+	registry, mailer := testContactRegistry(t)
+	tourist := "did:sih:tourist_1"
+
+	registration, err := registry.create(ctx, tourist, ContactRequest{Name: "Asha", Phone: "9876543210", Email: "asha@example.com", Actor: "tourist_app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	contact := registration.Contact
+	if contact.Phone != "+919876543210" || len(contact.Channels) != 2 || contact.Priority != 1 {
+		t.Errorf("expected a normalized phone, both channels and priority 1, got %+v", contact)
+	}
+	if len(registration.CodesSent) != 1 || registration.CodesSent[0] != contactChannelEmail {
+		t.Errorf("expected a code by email only, as SMS is not configured, got %v", registration.CodesSent)
+	}
+
+	// Until verified, the configured directory still answers
+	if contacts, _ := registry.contacts(ctx, tourist); len(contacts) != 1 || contacts[0].Name != "Hotel desk" {
+		t.Errorf("expected the fallback directory, got %+v", contacts)
+	}
+
+	code := sentCode(t, mailer)
+	wrong := "000000"
+	if code == wrong {
+		wrong = "111111"
+	}
+	if _, err := registry.verify(ctx, tourist, contact.ContactID, VerifyContactRequest{Channel: contactChannelEmail, Code: wrong}); !errors.Is(err, errContactCodeWrong) {
+		t.Errorf("expected a wrong code rejected, got %v", err)
+	}
+	if _, err := registry.verify(ctx, tourist, contact.ContactID, VerifyContactRequest{Channel: contactChannelSMS, Code: code}); !errors.Is(err, errContactCodeNone) {
+		t.Errorf("expected no code pending by SMS, got %v", err)
+	}
+	verified, err := registry.verify(ctx, tourist, contact.ContactID, VerifyContactRequest{Channel: contactChannelEmail, Code: code})
+	if err != nil || !verified.EmailVerified || verified.PhoneVerified {
+		t.Fatalf("expected the email verified, got %+v %v", verified, err)
+	}
+	contacts, _ := registry.contacts(ctx, tourist)
+	if len(contacts) != 1 || contacts[0].Email != "asha@example.com" || contacts[0].Phone != "" {
+		t.Errorf("expected only the verified email notified, got %+v", contacts)
+	}
+	if _, err := registry.verify(ctx, tourist, contact.ContactID, VerifyContactRequest{Channel: contactChannelEmail, Code: code}); !errors.Is(err, errContactCodeNone) {
+		t.Errorf("expected a code to work once, got %v", err)
+	}
+
+	// Changing the email needs a new verification, and wrong guesses use up the code
+	registration, err = registry.update(ctx, tourist, contact.ContactID, ContactRequest{Name: "Asha", Email: "asha@example.org", Actor: "tourist_app"})
+	if err != nil || registration.Contact.EmailVerified || len(registration.CodesSent) != 1 {
+		t.Fatalf("expected the new email unverified with a code sent, got %+v %v", registration, err)
+	}
+	code = sentCode(t, mailer)
+	if code == wrong {
+		wrong = "222222"
+	}
+	for range registry.maxAttempts {
+		registry.verify(ctx, tourist, contact.ContactID, VerifyContactRequest{Channel: contactChannelEmail, Code: wrong})
+	}
+	if _, err := registry.verify(ctx, tourist, contact.ContactID, VerifyContactRequest{Channel: contactChannelEmail, Code: code}); !errors.Is(err, errContactCodeNone) {
+		t.Errorf("expected the code dropped after too many attempts, got %v", err)
+	}
+}
+
+func TestContactPriorities(t *testing.T) {
+	ctx := context.Background()
+	registry, _ := testContactRegistry(t)
+	tourist := "did:sih:tourist_2"
+	add := func(name string, priority int) string {
+		registration, err := registry.create(ctx, tourist, ContactRequest{Name: name, Email: name + "@example.com", Priority: priority, Actor: "tourist_app"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return registration.Contact.ContactID
+	}
+	order := func() []string {
+		contacts, _ := registry.store.list(ctx, tourist)
+		var names []string
+		for i, c := range contacts {
+			if c.Priority != i+1 {
+				t.Errorf("expected priorities without gaps, got %d at %d", c.Priority, i)
+			}
+			names = append(names, c.Name)
+		}
+		return names
+	}
+
+	add("father", 0)
+	mother := add("mother", 0)
+	add("friend", 1)
+	if got := order(); len(got) != 3 || got[0] != "friend" || got[1] != "father" || got[2] != "mother" {
+		t.Errorf("expected the friend first, got %v", got)
+	}
+	if _, err := registry.create(ctx, tourist, ContactRequest{Name: "sister", Email: "sister@example.com", Actor: "tourist_app"}); !errors.Is(err, errContactLimit) {
+		t.Errorf("expected the contact limit enforced, got %v", err)
+	}
+
+	if _, err := registry.update(ctx, tourist, mother, ContactRequest{Name: "mother", Email: "mother@example.com", Priority: 1, Actor: "tourist_app"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := order(); got[0] != "mother" || got[1] != "friend" || got[2] != "father" {
+		t.Errorf("expected the mother moved first, got %v", got)
+	}
+	if err := registry.remove(ctx, tourist, mother); err != nil {
+		t.Fatal(err)
+	}
+	if got := order(); len(got) != 2 || got[0] != "friend" {
+		t.Errorf("expected the others moved up, got %v", got)
+	}
+	if err := registry.remove(ctx, tourist, mother); !errors.Is(err, errContactNotFound) {
+		t.Errorf("expected a removed contact not found, got %v", err)
+	}
+}
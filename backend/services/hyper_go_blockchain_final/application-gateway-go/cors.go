@@ -0,0 +1,139 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Origins the dashboards are served from during local development
+var developmentOrigins = []string{
+	"http://localhost:3000",
+	"http://127.0.0.1:3000",
+}
+
+// CORSConfig is the cross-origin policy applied to browser requests
+type CORSConfig struct {
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	MaxAge           time.Duration
+}
+
+// loadCORSConfig reads CORS_* settings. Development allows the local dashboards by default; any
+// other APP_ENV allows no origins until CORS_ALLOWED_ORIGINS lists the dashboard domains.
+func loadCORSConfig() CORSConfig {
+	defaultOrigins := ""
+	if getEnv("APP_ENV", "production") == "development" {
+		defaultOrigins = strings.Join(developmentOrigins, ",")
+	}
+
+	cfg := CORSConfig{
+		AllowedOrigins:   splitList(getEnv("CORS_ALLOWED_ORIGINS", defaultOrigins)),
+		AllowedMethods:   splitList(getEnv("CORS_ALLOWED_METHODS", "GET,POST,PUT,DELETE,OPTIONS")),
+		AllowedHeaders:   splitList(getEnv("CORS_ALLOWED_HEADERS", "Authorization,Content-Type,Accept,Origin,Cache-Control,X-Requested-With,X-API-Key,X-Fabric-Target,Idempotency-Key,If-None-Match,X-Device-ID,X-Signature,X-Signature-Timestamp,X-Signature-Nonce,X-WebAuthn-Assertion,traceparent")),
+		ExposedHeaders:   splitList(getEnv("CORS_EXPOSED_HEADERS", "Retry-After,X-Fabric-Target,Idempotent-Replayed,ETag,traceparent")),
+		AllowCredentials: getEnvBool("CORS_ALLOW_CREDENTIALS", true),
+		MaxAge:           getEnvDuration("CORS_MAX_AGE", 10*time.Minute),
+	}
+
+	for _, origin := range cfg.AllowedOrigins {
+		if origin == "*" && cfg.AllowCredentials {
+			// Browsers reject a wildcard origin on credentialed requests, so never send both
+			log.Println("⚠️  CORS_ALLOWED_ORIGINS contains *, disabling credentialed CORS requests")
+			cfg.AllowCredentials = false
+			break
+		}
+	}
+	return cfg
+}
+
+// allowsOrigin matches an exact origin, "*", or a subdomain pattern such as https://*.example.org
+func (cfg CORSConfig) allowsOrigin(origin string) bool {
+	for _, allowed := range cfg.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+		if scheme, suffix, ok := strings.Cut(allowed, "://*."); ok {
+			host, found := strings.CutPrefix(strings.ToLower(origin), strings.ToLower(scheme)+"://")
+			if found && strings.HasSuffix(host, "."+strings.ToLower(suffix)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// corsMiddleware answers preflight requests and reflects allowed origins on actual requests
+func corsMiddleware(cfg CORSConfig) gin.HandlerFunc {
+	if len(cfg.AllowedOrigins) == 0 {
+		log.Println("🌐 No CORS origins configured, cross-origin browser requests will be refused")
+	} else {
+		log.Printf("🌐 CORS allowed origins: %s", strings.Join(cfg.AllowedOrigins, ", "))
+	}
+
+	methods := strings.Join(cfg.AllowedMethods, ", ")
+	headers := strings.Join(cfg.AllowedHeaders, ", ")
+	exposed := strings.Join(cfg.ExposedHeaders, ", ")
+	maxAge := strconv.Itoa(int(cfg.MaxAge.Seconds()))
+
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin == "" {
+			c.Next()
+			return
+		}
+
+		c.Writer.Header().Add("Vary", "Origin")
+		preflight := c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != ""
+		if !cfg.allowsOrigin(origin) {
+			if preflight {
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+			c.Next()
+			return
+		}
+
+		c.Header("Access-Control-Allow-Origin", origin)
+		if cfg.AllowCredentials {
+			c.Header("Access-Control-Allow-Credentials", "true")
+		}
+
+		if preflight {
+			c.Header("Access-Control-Allow-Methods", methods)
+			c.Header("Access-Control-Allow-Headers", headers)
+			c.Header("Access-Control-Max-Age", maxAge)
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+
+		if exposed != "" {
+			c.Header("Access-Control-Expose-Headers", exposed)
+		}
+		c.Next()
+	}
+}
+
+// splitList parses a comma-separated setting, dropping blanks
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,59 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCORSMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(corsMiddleware(CORSConfig{
+		AllowedOrigins:   []string{"https://dashboard.example.org", "https://*.police.example.org"},
+		AllowedMethods:   []string{"GET", "POST"},
+		AllowedHeaders:   []string{"Authorization", "Content-Type"},
+		AllowCredentials: true,
+		MaxAge:           time.Minute,
+	}))
+	r.GET("/ping", func(c *gin.Context) { c.String(http.StatusOK, "pong") })
+
+	tests := []struct {
+		name        string
+		method      string
+		origin      string
+		wantStatus  int
+		wantAllowed string
+	}{
+		{"allowed origin", http.MethodGet, "https://dashboard.example.org", http.StatusOK, "https://dashboard.example.org"},
+		{"allowed subdomain", http.MethodGet, "https://cad.police.example.org", http.StatusOK, "https://cad.police.example.org"},
+		{"unknown origin", http.MethodGet, "https://evil.example.com", http.StatusOK, ""},
+		{"allowed preflight", http.MethodOptions, "https://dashboard.example.org", http.StatusNoContent, "https://dashboard.example.org"},
+		{"refused preflight", http.MethodOptions, "https://evil.example.com", http.StatusForbidden, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/ping", nil)
+			req.Header.Set("Origin", tt.origin)
+			if tt.method == http.MethodOptions {
+				req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.wantAllowed {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.wantAllowed)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got == "*" {
+				t.Error("wildcard origin sent with credentials")
+			}
+		})
+	}
+}
@@ -0,0 +1,779 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+const (
+	credentialKeyPrefix    = "sih:vc:cred:"
+	credentialDIDKeyPrefix = "sih:vc:did:"
+	credentialStatusPrefix = "sih:vc:status:"
+	credentialIndexKey     = "sih:vc:next_index"
+
+	// statusListSize is the number of entries in each status list, the StatusList2021 minimum of
+	// 16KB so a list does not narrow down which credential a verifier is checking
+	statusListSize     = 131072
+	maxClaimLength     = 200
+	maxCredentialToken = 8192
+
+	errCodeCredentialsDisabled = "CREDENTIALS_DISABLED"
+	vcMediaType                = "application/vc+jwt"
+)
+
+// Credential verification failures; the messages double as verdict reasons
+var (
+	errCredentialMalformed    = errors.New("malformed")
+	errCredentialUnknownKey   = errors.New("unknown_key")
+	errCredentialBadSignature = errors.New("invalid_signature")
+)
+
+// credentialType is a kind of credential the gateway issues, with the claims it carries
+type credentialType struct {
+	vcType   string
+	required []string
+	optional []string
+}
+
+var credentialTypes = map[string]credentialType{
+	"tourist_identity":   {"TouristIdentityCredential", []string{"name", "nationality"}, []string{"documentType", "documentNumberHash"}},
+	"trek_permit":        {"TrekPermitCredential", []string{"permitNumber", "route", "validFrom", "validUntil"}, []string{"region", "issuingAuthority"}},
+	"insurance_coverage": {"InsuranceCoverageCredential", []string{"insurer", "policyNumber", "coverageAmount", "validUntil"}, []string{"currency", "emergencyContact"}},
+}
+
+func credentialTypeNames() []string {
+	names := make([]string, 0, len(credentialTypes))
+	for name := range credentialTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// IssueCredentialRequest mints a credential for the holder of a DID. ExpiresAt defaults to the
+// claims' validUntil, or the DID's expiry, and can never be later than the DID's expiry.
+type IssueCredentialRequest struct {
+	Type      string            `json:"type" binding:"required"`
+	DigitalID string            `json:"digitalID" binding:"required"`
+	Claims    map[string]string `json:"claims" binding:"required"`
+	ExpiresAt string            `json:"expiresAt"`
+	IssuedBy  string            `json:"issuedBy" binding:"required"`
+}
+
+func (r IssueCredentialRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.digitalID("digitalID", r.DigitalID)
+	v.identifier("issuedBy", r.IssuedBy)
+	v.oneOf("type", r.Type, credentialTypeNames())
+	if kind, ok := credentialTypes[r.Type]; ok {
+		for _, name := range kind.required {
+			if r.Claims[name] == "" {
+				v.add("claims."+name, "is required for %s", r.Type)
+			}
+		}
+		for name, value := range r.Claims {
+			if !slices.Contains(kind.required, name) && !slices.Contains(kind.optional, name) {
+				v.add("claims."+name, "is not a %s claim", r.Type)
+			} else if len(value) > maxClaimLength {
+				v.add("claims."+name, "must be at most %d characters", maxClaimLength)
+			}
+		}
+		for _, name := range []string{"validFrom", "validUntil"} {
+			if value, ok := r.Claims[name]; ok && value != "" {
+				v.rfc3339("claims."+name, value)
+			}
+		}
+	}
+	if r.ExpiresAt != "" {
+		v.future("expiresAt", r.ExpiresAt)
+	}
+	return v.errors
+}
+
+// IssuedCredential is the gateway's record of a credential it minted. The credential itself is
+// only returned to the caller; the record keeps what revocation needs.
+type IssuedCredential struct {
+	CredentialID string `json:"credential_id"`
+	Type         string `json:"type"`
+	DigitalID    string `json:"digital_id"`
+	StatusList   int    `json:"status_list"`
+	StatusIndex  int    `json:"status_index"`
+	IssuedBy     string `json:"issued_by"`
+	IssuedAt     string `json:"issued_at"`
+	ExpiresAt    string `json:"expires_at"`
+	Revoked      bool   `json:"revoked"`
+	RevokedAt    string `json:"revoked_at,omitempty"`
+	RevokedBy    string `json:"revoked_by,omitempty"`
+}
+
+// CredentialListRequest names the DID whose credentials to list
+type CredentialListRequest struct {
+	DigitalID string `form:"digitalID" binding:"required"`
+}
+
+func (r CredentialListRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.digitalID("digitalID", r.DigitalID)
+	return v.errors
+}
+
+// IssuedCredentialResponse carries the signed VC-JWT alongside its record
+type IssuedCredentialResponse struct {
+	IssuedCredential
+	JWT string `json:"jwt"`
+}
+
+// VerifyCredentialRequest carries a VC-JWT presented to a third party
+type VerifyCredentialRequest struct {
+	JWT string `json:"jwt" binding:"required"`
+}
+
+// CredentialVerification is the verdict on a presented credential. Valid requires a good
+// signature, an unexpired credential whose status bit is clear and a DID that still verifies on
+// the ledger.
+type CredentialVerification struct {
+	Valid          bool                   `json:"valid"`
+	SignatureValid bool                   `json:"signature_valid"`
+	Expired        bool                   `json:"expired"`
+	Revoked        bool                   `json:"revoked"`
+	Reason         string                 `json:"reason,omitempty"`
+	Credential     map[string]interface{} `json:"credential,omitempty"`
+	Ledger         *DIDVerification       `json:"ledger,omitempty"`
+}
+
+// credentialStore keeps credential records and the revocation status lists
+type credentialStore interface {
+	// allocate reserves the next status list position, counting from 0 across all lists
+	allocate(ctx context.Context) (int, error)
+	get(ctx context.Context, credentialID string) (*IssuedCredential, error)
+	put(ctx context.Context, record IssuedCredential) error
+	// listByDID returns the IDs of the credentials issued to a DID
+	listByDID(ctx context.Context, digitalID string) ([]string, error)
+	setRevoked(ctx context.Context, list, index int) error
+	// statusList returns a list's bitstring, most significant bit first, which may be shorter
+	// than the list when its last entries are unrevoked
+	statusList(ctx context.Context, list int) ([]byte, error)
+}
+
+// credentialIssuer mints VC-JWTs signed with the issuer DID's Ed25519 key
+type credentialIssuer struct {
+	store     credentialStore
+	signer    *qrSigner
+	issuerDID string
+	baseURL   string
+}
+
+var vcIssuer *credentialIssuer
+
+// initCredentials runs after initDocumentCache
+func initCredentials() {
+	if !getEnvBool("VC_ENABLED", false) {
+		log.Println("🪪 VC_ENABLED not set, verifiable credentials disabled")
+		return
+	}
+	issuer := &credentialIssuer{
+		issuerDID: getEnv("VC_ISSUER_DID", "did:sih:issuer"),
+		baseURL:   strings.TrimRight(getEnv("VC_BASE_URL", ""), "/"),
+	}
+	if !digitalIDRegex.MatchString(issuer.issuerDID) {
+		panic(fmt.Errorf("VC_ISSUER_DID must be a DID of the form did:<method>:<identifier>"))
+	}
+	if issuer.baseURL == "" {
+		panic(fmt.Errorf("VC_BASE_URL must be set to the gateway's public URL, which status list URLs are built on"))
+	}
+
+	if path := getEnv("VC_SIGNING_KEY_FILE", ""); path != "" {
+		signer, err := loadQRSigner(path)
+		if err != nil {
+			panic(fmt.Errorf("failed to load credential signing key: %w", err))
+		}
+		issuer.signer = signer
+	} else {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			panic(fmt.Errorf("failed to generate credential signing key: %w", err))
+		}
+		issuer.signer = newQRSigner(key)
+		log.Printf("⚠️  VC_SIGNING_KEY_FILE not set; credentials are signed with an ephemeral key (%s)", issuer.signer.keyID)
+	}
+
+	backend := "memory"
+	issuer.store = newMemoryCredentialStore()
+	if documentCache != nil {
+		issuer.store, backend = redisCredentialStore{documentCache}, "Redis"
+	}
+	vcIssuer = issuer
+	log.Printf("🪪 Issuing verifiable credentials as %s with key %s, stored in %s", issuer.issuerDID, issuer.signer.keyID, backend)
+}
+
+// verificationMethod is the DID URL of the issuer's signing key
+func (ci *credentialIssuer) verificationMethod() string {
+	return ci.issuerDID + "#" + ci.signer.keyID
+}
+
+// publicJWK is the issuer's Ed25519 public key as a JSON Web Key
+func (ci *credentialIssuer) publicJWK() gin.H {
+	return ci.signer.publicJWK(ci.verificationMethod())
+}
+
+func (ci *credentialIssuer) statusListURL(list int) string {
+	return fmt.Sprintf("%s/credentials/status/%d", ci.baseURL, list)
+}
+
+// signJWT encodes claims as a compact JWS signed with the issuer key
+func (ci *credentialIssuer) signJWT(typ string, claims interface{}) (string, error) {
+	return ci.signer.signJWT(typ, ci.verificationMethod(), claims)
+}
+
+// verifyJWT checks a compact JWS signed by the issuer key and returns its payload
+func (ci *credentialIssuer) verifyJWT(token string) (map[string]interface{}, error) {
+	return ci.signer.verifyJWT(token, ci.verificationMethod())
+}
+
+// issue mints a VC-JWT for a DID that verifies on the ledger, reserving its status list entry
+func (ci *credentialIssuer) issue(ctx context.Context, req IssueCredentialRequest) (*IssuedCredentialResponse, error) {
+	if errs := req.Validate(); len(errs) > 0 {
+		return nil, errs
+	}
+	verdict, err := ledger.VerifyDID(ctx, req.DigitalID)
+	if err != nil {
+		return nil, err
+	}
+	if !verdict.Valid {
+		return nil, ValidationErrors{{Field: "digitalID", Message: "must verify on the ledger, but it is " + strings.ReplaceAll(verdict.Reason, "_", " ")}}
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	didExpires, _ := time.Parse(time.RFC3339, verdict.ExpiresAt)
+	expires := didExpires
+	for _, candidate := range []string{req.Claims["validUntil"], req.ExpiresAt} {
+		if at, err := time.Parse(time.RFC3339, candidate); err == nil && at.Before(expires) {
+			expires = at
+		}
+	}
+	if req.ExpiresAt != "" {
+		if at, _ := time.Parse(time.RFC3339, req.ExpiresAt); at.After(didExpires) {
+			return nil, ValidationErrors{{Field: "expiresAt", Message: "must not be after the DID expires at " + verdict.ExpiresAt}}
+		}
+	}
+	if !expires.After(now) {
+		return nil, ValidationErrors{{Field: "claims.validUntil", Message: "must be in the future"}}
+	}
+
+	position, err := ci.store.allocate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	record := IssuedCredential{
+		CredentialID: "urn:uuid:" + newUUID(),
+		Type:         req.Type,
+		DigitalID:    req.DigitalID,
+		StatusList:   position/statusListSize + 1,
+		StatusIndex:  position % statusListSize,
+		IssuedBy:     req.IssuedBy,
+		IssuedAt:     now.Format(time.RFC3339),
+		ExpiresAt:    expires.UTC().Format(time.RFC3339),
+	}
+
+	token, err := ci.mint(record, req.Claims)
+	if err != nil {
+		return nil, err
+	}
+	if err := ci.store.put(ctx, record); err != nil {
+		return nil, err
+	}
+	return &IssuedCredentialResponse{IssuedCredential: record, JWT: token}, nil
+}
+
+// mint signs the VC-JWT for a credential record
+func (ci *credentialIssuer) mint(record IssuedCredential, claims map[string]string) (string, error) {
+	issued, _ := time.Parse(time.RFC3339, record.IssuedAt)
+	expires, _ := time.Parse(time.RFC3339, record.ExpiresAt)
+	subject := map[string]interface{}{"id": record.DigitalID}
+	for name, value := range claims {
+		subject[name] = value
+	}
+	listURL := ci.statusListURL(record.StatusList)
+	vc := map[string]interface{}{
+		"@context":          []string{"https://www.w3.org/2018/credentials/v1", "https://w3id.org/vc/status-list/2021/v1"},
+		"id":                record.CredentialID,
+		"type":              []string{"VerifiableCredential", credentialTypes[record.Type].vcType},
+		"issuer":            ci.issuerDID,
+		"issuanceDate":      record.IssuedAt,
+		"expirationDate":    record.ExpiresAt,
+		"credentialSubject": subject,
+		"credentialStatus": map[string]string{
+			"id":                   listURL + "#" + strconv.Itoa(record.StatusIndex),
+			"type":                 "StatusList2021Entry",
+			"statusPurpose":        "revocation",
+			"statusListIndex":      strconv.Itoa(record.StatusIndex),
+			"statusListCredential": listURL,
+		},
+	}
+	return ci.signJWT("JWT", map[string]interface{}{
+		"iss": ci.issuerDID,
+		"sub": record.DigitalID,
+		"jti": record.CredentialID,
+		"nbf": issued.Unix(),
+		"exp": expires.Unix(),
+		"vc":  vc,
+	})
+}
+
+// revoke sets a credential's status bit. Revoking twice keeps the first revocation.
+func (ci *credentialIssuer) revoke(ctx context.Context, credentialID string, req DeleteRequest) (*IssuedCredential, error) {
+	if errs := req.Validate(); len(errs) > 0 {
+		return nil, errs
+	}
+	record, err := ci.store.get(ctx, credentialID)
+	if err != nil {
+		return nil, err
+	}
+	if record == nil {
+		return nil, fmt.Errorf("the credential %s does not exist", credentialID)
+	}
+	if record.Revoked {
+		return record, nil
+	}
+	if err := ci.store.setRevoked(ctx, record.StatusList, record.StatusIndex); err != nil {
+		return nil, err
+	}
+	record.Revoked, record.RevokedAt, record.RevokedBy = true, time.Now().UTC().Format(time.RFC3339), req.Actor
+	return record, ci.store.put(ctx, *record)
+}
+
+// revokeDID revokes every credential issued to a DID, which is how they follow the DID's
+// revocation on the ledger
+func (ci *credentialIssuer) revokeDID(ctx context.Context, digitalID, actor string) (int, error) {
+	ids, err := ci.store.listByDID(ctx, digitalID)
+	if err != nil {
+		return 0, err
+	}
+	revoked := 0
+	for _, id := range ids {
+		record, err := ci.revoke(ctx, id, DeleteRequest{Actor: actor})
+		if err != nil {
+			if translateFabricError(err).Code == errCodeNotFound {
+				continue
+			}
+			return revoked, err
+		}
+		if record.RevokedBy == actor {
+			revoked++
+		}
+	}
+	return revoked, nil
+}
+
+func (ci *credentialIssuer) list(ctx context.Context, req CredentialListRequest) ([]IssuedCredential, error) {
+	if errs := req.Validate(); len(errs) > 0 {
+		return nil, errs
+	}
+	ids, err := ci.store.listByDID(ctx, req.DigitalID)
+	if err != nil {
+		return nil, err
+	}
+	records := []IssuedCredential{}
+	for _, id := range ids {
+		record, err := ci.store.get(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if record != nil {
+			records = append(records, *record)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].IssuedAt < records[j].IssuedAt })
+	return records, nil
+}
+
+// statusListCredential is the signed StatusList2021Credential for a list, GZIP-compressed and
+// base64url-encoded as the spec requires
+func (ci *credentialIssuer) statusListCredential(ctx context.Context, list int) (string, error) {
+	bits, err := ci.store.statusList(ctx, list)
+	if err != nil {
+		return "", err
+	}
+	full := make([]byte, statusListSize/8)
+	copy(full, bits)
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	zw.Write(full)
+	if err := zw.Close(); err != nil {
+		return "", err
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	listURL := ci.statusListURL(list)
+	return ci.signJWT("JWT", map[string]interface{}{
+		"iss": ci.issuerDID,
+		"sub": listURL,
+		"nbf": now.Unix(),
+		"vc": map[string]interface{}{
+			"@context":     []string{"https://www.w3.org/2018/credentials/v1", "https://w3id.org/vc/status-list/2021/v1"},
+			"id":           listURL,
+			"type":         []string{"VerifiableCredential", "StatusList2021Credential"},
+			"issuer":       ci.issuerDID,
+			"issuanceDate": now.Format(time.RFC3339),
+			"credentialSubject": map[string]string{
+				"id":            listURL + "#list",
+				"type":          "StatusList2021",
+				"statusPurpose": "revocation",
+				"encodedList":   base64.RawURLEncoding.EncodeToString(compressed.Bytes()),
+			},
+		},
+	})
+}
+
+// verify checks a presented credential: its signature, its validity period, its status list bit
+// and the DID it is bound to on the ledger
+func (ci *credentialIssuer) verify(ctx context.Context, token string) (CredentialVerification, error) {
+	if len(token) > maxCredentialToken {
+		return CredentialVerification{}, ValidationErrors{{Field: "jwt", Message: fmt.Sprintf("must be at most %d bytes", maxCredentialToken)}}
+	}
+	claims, err := ci.verifyJWT(strings.TrimSpace(token))
+	vc, _ := claims["vc"].(map[string]interface{})
+	if err != nil {
+		return CredentialVerification{Reason: err.Error(), Credential: vc}, nil
+	}
+	result := CredentialVerification{SignatureValid: true, Credential: vc}
+
+	exp, _ := claims["exp"].(float64)
+	nbf, _ := claims["nbf"].(float64)
+	now := float64(time.Now().Unix())
+	if exp == 0 || now >= exp || now < nbf {
+		result.Expired, result.Reason = true, "expired"
+		return result, nil
+	}
+
+	status, _ := vc["credentialStatus"].(map[string]interface{})
+	listURL, _ := status["statusListCredential"].(string)
+	index, err := strconv.Atoi(fmt.Sprint(status["statusListIndex"]))
+	list, listErr := strconv.Atoi(strings.TrimPrefix(listURL, ci.baseURL+"/credentials/status/"))
+	if err != nil || listErr != nil || index < 0 || index >= statusListSize {
+		result.Reason = errCredentialMalformed.Error()
+		return result, nil
+	}
+	bits, err := ci.store.statusList(ctx, list)
+	if err != nil {
+		return CredentialVerification{}, err
+	}
+	if index/8 < len(bits) && bits[index/8]&(0x80>>(index%8)) != 0 {
+		result.Revoked, result.Reason = true, "revoked"
+		return result, nil
+	}
+
+	subject, _ := claims["sub"].(string)
+	verdict, err := ledger.VerifyDID(ctx, subject)
+	if err != nil {
+		return CredentialVerification{}, err
+	}
+	result.Ledger = &verdict
+	result.Valid, result.Revoked, result.Reason = verdict.Valid, verdict.Revoked, verdict.Reason
+	return result, nil
+}
+
+// credentialsFromEvent revokes a DID's credentials when the DID is deleted, which revokes it on
+// the ledger. Every gateway applies the event; revoking is idempotent.
+func credentialsFromEvent(ctx context.Context, event *client.ChaincodeEvent) {
+	if vcIssuer == nil || event.EventName != "DeleteDID" || !isDefaultTarget(ctx) {
+		return
+	}
+	did, err := decodeDocument[DIDDocument](event.Payload, "DID event")
+	if err != nil || did.DigitalID == "" {
+		return
+	}
+	revoked, err := vcIssuer.revokeDID(ctx, did.DigitalID, "ledger:"+event.TransactionID)
+	if err != nil {
+		log.Printf("🪪 Failed to revoke credentials of %s: %v", did.DigitalID, err)
+		return
+	}
+	if revoked > 0 {
+		log.Printf("🪪 Revoked %d credentials of deleted DID %s", revoked, did.DigitalID)
+	}
+}
+
+// newUUID returns a random (version 4) UUID
+func newUUID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// credentialsDisabled responds 404 when credentials are not enabled
+func credentialsDisabled(c *gin.Context) bool {
+	if vcIssuer != nil {
+		return false
+	}
+	respondError(c, http.StatusNotFound, errCodeCredentialsDisabled, "Verifiable credentials are not enabled")
+	return true
+}
+
+func issueCredential(c *gin.Context) {
+	if credentialsDisabled(c) {
+		return
+	}
+	var req IssueCredentialRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+	setAuditTarget(c, req.DigitalID)
+
+	issued, err := vcIssuer.issue(c.Request.Context(), req)
+	if err != nil {
+		respondServiceError(c, "Failed to issue credential", err)
+		return
+	}
+	respondData(c, http.StatusCreated, issued)
+}
+
+func listCredentials(c *gin.Context) {
+	if credentialsDisabled(c) {
+		return
+	}
+	var req CredentialListRequest
+	if !bindQuery(c, &req) {
+		return
+	}
+	records, err := vcIssuer.list(c.Request.Context(), req)
+	if err != nil {
+		respondServiceError(c, "Failed to list credentials", err)
+		return
+	}
+	respondData(c, http.StatusOK, records)
+}
+
+func revokeCredential(c *gin.Context) {
+	if credentialsDisabled(c) {
+		return
+	}
+	id := c.Param("id")
+	if !strings.HasPrefix(id, "urn:uuid:") || len(id) != len("urn:uuid:")+36 {
+		respondValidationErrors(c, ValidationErrors{{Field: "id", Message: "must be a credential ID of the form urn:uuid:<uuid>"}})
+		return
+	}
+	var req DeleteRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+	setAuditTarget(c, id)
+
+	record, err := vcIssuer.revoke(c.Request.Context(), id, req)
+	if err != nil {
+		respondServiceError(c, "Failed to revoke credential", err)
+		return
+	}
+	respondData(c, http.StatusOK, record)
+}
+
+// getStatusList serves a status list credential to verifiers, without an API key
+func getStatusList(c *gin.Context) {
+	if credentialsDisabled(c) {
+		return
+	}
+	list, err := strconv.Atoi(c.Param("list"))
+	if err != nil || list < 1 {
+		respondValidationErrors(c, ValidationErrors{{Field: "list", Message: "must be a positive integer"}})
+		return
+	}
+	token, err := vcIssuer.statusListCredential(c.Request.Context(), list)
+	if err != nil {
+		logWithContext(c.Request.Context(), "Failed to read status list %d: %v", list, err)
+		respondError(c, http.StatusServiceUnavailable, errCodeUnavailable, "The status list cannot be read right now")
+		return
+	}
+	c.Header("Cache-Control", "max-age=60")
+	c.Data(http.StatusOK, vcMediaType, []byte(token))
+}
+
+// getCredentialKeys publishes the issuer's public key as a JWK Set for verifiers checking
+// credentials offline
+func getCredentialKeys(c *gin.Context) {
+	if credentialsDisabled(c) {
+		return
+	}
+	c.Header("Cache-Control", "max-age=300")
+	c.JSON(http.StatusOK, gin.H{"keys": []gin.H{vcIssuer.publicJWK()}})
+}
+
+// verifyCredential checks a credential presented to a third party, without an API key
+func verifyCredential(c *gin.Context) {
+	if credentialsDisabled(c) {
+		return
+	}
+	var req VerifyCredentialRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+	verdict, err := vcIssuer.verify(c.Request.Context(), req.JWT)
+	if err != nil {
+		respondServiceError(c, "Failed to verify credential", err)
+		return
+	}
+	respondData(c, http.StatusOK, verdict)
+}
+
+// redisCredentialStore keeps each record under its ID, a set of IDs per DID, a counter for status
+// list positions and each status list as a Redis bitmap, whose bit order matches StatusList2021
+type redisCredentialStore struct {
+	redis *redisClient
+}
+
+func (s redisCredentialStore) allocate(ctx context.Context) (int, error) {
+	reply, err := s.redis.Do(ctx, "INCR", credentialIndexKey)
+	if err != nil {
+		return 0, err
+	}
+	next, _ := reply.(int64)
+	return int(next - 1), nil
+}
+
+func (s redisCredentialStore) get(ctx context.Context, credentialID string) (*IssuedCredential, error) {
+	data, err := s.redis.Get(ctx, credentialKeyPrefix+credentialID)
+	if errors.Is(err, errRedisNil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var record IssuedCredential
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// put keeps a record until its credential expires, after which its status bit no longer matters
+func (s redisCredentialStore) put(ctx context.Context, record IssuedCredential) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	expires, _ := time.Parse(time.RFC3339, record.ExpiresAt)
+	if err := s.redis.Set(ctx, credentialKeyPrefix+record.CredentialID, data, max(time.Until(expires), time.Minute)); err != nil {
+		return err
+	}
+	_, err = s.redis.Do(ctx, "SADD", credentialDIDKeyPrefix+record.DigitalID, record.CredentialID)
+	return err
+}
+
+func (s redisCredentialStore) listByDID(ctx context.Context, digitalID string) ([]string, error) {
+	reply, err := s.redis.Do(ctx, "SMEMBERS", credentialDIDKeyPrefix+digitalID)
+	if err != nil {
+		return nil, err
+	}
+	members, _ := reply.([]interface{})
+	ids := make([]string, 0, len(members))
+	for _, member := range members {
+		raw, _ := member.([]byte)
+		ids = append(ids, string(raw))
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func (s redisCredentialStore) setRevoked(ctx context.Context, list, index int) error {
+	_, err := s.redis.Do(ctx, "SETBIT", credentialStatusPrefix+strconv.Itoa(list), strconv.Itoa(index), "1")
+	return err
+}
+
+func (s redisCredentialStore) statusList(ctx context.Context, list int) ([]byte, error) {
+	bits, err := s.redis.Get(ctx, credentialStatusPrefix+strconv.Itoa(list))
+	if errors.Is(err, errRedisNil) {
+		return nil, nil
+	}
+	return bits, err
+}
+
+// memoryCredentialStore serves a single gateway instance
+type memoryCredentialStore struct {
+	mu      sync.Mutex
+	next    int
+	records map[string]IssuedCredential
+	lists   map[int][]byte
+}
+
+func newMemoryCredentialStore() *memoryCredentialStore {
+	return &memoryCredentialStore{records: map[string]IssuedCredential{}, lists: map[int][]byte{}}
+}
+
+func (s *memoryCredentialStore) allocate(_ context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.next++
+	return s.next - 1, nil
+}
+
+func (s *memoryCredentialStore) get(_ context.Context, credentialID string) (*IssuedCredential, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record, ok := s.records[credentialID]
+	if !ok {
+		return nil, nil
+	}
+	return &record, nil
+}
+
+func (s *memoryCredentialStore) put(_ context.Context, record IssuedCredential) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[record.CredentialID] = record
+	return nil
+}
+
+func (s *memoryCredentialStore) listByDID(_ context.Context, digitalID string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ids []string
+	for id, record := range s.records {
+		if record.DigitalID == digitalID {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func (s *memoryCredentialStore) setRevoked(_ context.Context, list, index int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	bits := s.lists[list]
+	if len(bits) <= index/8 {
+		bits = append(bits, make([]byte, index/8+1-len(bits))...)
+	}
+	bits[index/8] |= 0x80 >> (index % 8)
+	s.lists[list] = bits
+	return nil
+}
+
+func (s *memoryCredentialStore) statusList(_ context.Context, list int) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return bytes.Clone(s.lists[list]), nil
+}
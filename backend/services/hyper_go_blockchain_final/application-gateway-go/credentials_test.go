@@ -0,0 +1,174 @@
+package gateway
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testCredentialIssuer(t *testing.T) *credentialIssuer {
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &credentialIssuer{
+		store:     newMemoryCredentialStore(),
+		signer:    newQRSigner(key),
+		issuerDID: "did:sih:issuer",
+		baseURL:   "https://gateway.example",
+	}
+}
+
+func testCredentialRecord(id string, index int, expires time.Time) IssuedCredential {
+	return IssuedCredential{
+		CredentialID: id,
+		Type:         "trek_permit",
+		DigitalID:    "did:sih:tourist_1",
+		StatusList:   1,
+		StatusIndex:  index,
+		IssuedBy:     "permit-office",
+		IssuedAt:     time.Now().UTC().Add(-time.Minute).Format(time.RFC3339),
+		ExpiresAt:    expires.UTC().Format(time.RFC3339),
+	}
+}
+
+func TestIssueCredentialRequestValidate(t *testing.T) {
+	valid := IssueCredentialRequest{
+		Type:      "trek_permit",
+		DigitalID: "did:sih:tourist_1",
+		IssuedBy:  "permit-office",
+		Claims: map[string]string{
+			"permitNumber": "TP-001",
+			"route":        "Goecha La",
+			"validFrom":    "2026-01-01T00:00:00Z",
+			"validUntil":   "2099-01-01T00:00:00Z",
+		},
+	}
+	if errs := valid.Validate(); len(errs) > 0 {
+		t.Fatalf("expected a valid request, got %v", errs)
+	}
+
+	for name, tt := range map[string]struct {
+		mutate func(*IssueCredentialRequest)
+		field  string
+	}{
+		"unknown type":  {func(r *IssueCredentialRequest) { r.Type = "passport" }, "type"},
+		"missing claim": {func(r *IssueCredentialRequest) { delete(r.Claims, "route") }, "claims.route"},
+		"foreign claim": {func(r *IssueCredentialRequest) { r.Claims["insurer"] = "Acme" }, "claims.insurer"},
+		"bad date":      {func(r *IssueCredentialRequest) { r.Claims["validUntil"] = "next year" }, "claims.validUntil"},
+		"past expiry":   {func(r *IssueCredentialRequest) { r.ExpiresAt = "2000-01-01T00:00:00Z" }, "expiresAt"},
+	} {
+		req := valid
+		req.Claims = map[string]string{}
+		for k, v := range valid.Claims {
+			req.Claims[k] = v
+		}
+		tt.mutate(&req)
+		errs := req.Validate()
+		found := false
+		for _, err := range errs {
+			found = found || err.Field == tt.field
+		}
+		if !found {
+			t.Errorf("%s: expected an error on %s, got %v", name, tt.field, errs)
+		}
+	}
+}
+
+func TestCredentialSignatureAndRevocation(t *testing.T) {
+	ctx := context.Background()
+	issuer := testCredentialIssuer(t)
+	record := testCredentialRecord("urn:uuid:00000000-0000-4000-8000-000000000001", 9, time.Now().Add(time.Hour))
+	if err := issuer.store.put(ctx, record); err != nil {
+		t.Fatal(err)
+	}
+	token, err := issuer.mint(record, map[string]string{"permitNumber": "TP-001"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := issuer.verifyJWT(token)
+	if err != nil {
+		t.Fatalf("expected the credential to verify, got %v", err)
+	}
+	vc := claims["vc"].(map[string]interface{})
+	status := vc["credentialStatus"].(map[string]interface{})
+	if status["statusListIndex"] != "9" || status["statusListCredential"] != "https://gateway.example/credentials/status/1" {
+		t.Errorf("unexpected credential status %v", status)
+	}
+	if subject := vc["credentialSubject"].(map[string]interface{}); subject["id"] != "did:sih:tourist_1" || subject["permitNumber"] != "TP-001" {
+		t.Errorf("unexpected credential subject %v", subject)
+	}
+
+	parts := strings.Split(token, ".")
+	tampered := parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"did:sih:someone_else"}`)) + "." + parts[2]
+	if verdict, err := issuer.verify(ctx, tampered); err != nil || verdict.SignatureValid || verdict.Reason != "invalid_signature" {
+		t.Errorf("expected a tampered credential rejected, got %+v %v", verdict, err)
+	}
+	if verdict, err := testCredentialIssuer(t).verify(ctx, token); err != nil || verdict.Reason != "unknown_key" {
+		t.Errorf("expected another issuer's key rejected, got %+v %v", verdict, err)
+	}
+
+	if _, err := issuer.revokeDID(ctx, record.DigitalID, "ledger:tx1"); err != nil {
+		t.Fatal(err)
+	}
+	verdict, err := issuer.verify(ctx, token)
+	if err != nil || verdict.Valid || !verdict.Revoked || !verdict.SignatureValid {
+		t.Errorf("expected a revoked credential, got %+v %v", verdict, err)
+	}
+	again, err := issuer.revoke(ctx, record.CredentialID, DeleteRequest{Actor: "admin"})
+	if err != nil || again.RevokedBy != "ledger:tx1" {
+		t.Errorf("expected the first revocation kept, got %+v %v", again, err)
+	}
+
+	expired := testCredentialRecord("urn:uuid:00000000-0000-4000-8000-000000000002", 10, time.Now().Add(-time.Second))
+	token, _ = issuer.mint(expired, nil)
+	if verdict, err := issuer.verify(ctx, token); err != nil || !verdict.Expired || verdict.Valid {
+		t.Errorf("expected an expired credential, got %+v %v", verdict, err)
+	}
+}
+
+func TestStatusListCredential(t *testing.T) {
+	ctx := context.Background()
+	issuer := testCredentialIssuer(t)
+	issuer.store.setRevoked(ctx, 1, 0)
+	issuer.store.setRevoked(ctx, 1, 12)
+
+	token, err := issuer.statusListCredential(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := issuer.verifyJWT(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list struct {
+		CredentialSubject struct {
+			EncodedList string `json:"encodedList"`
+		} `json:"credentialSubject"`
+	}
+	raw, _ := json.Marshal(claims["vc"])
+	json.Unmarshal(raw, &list)
+	compressed, err := base64.RawURLEncoding.DecodeString(list.CredentialSubject.EncodedList)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bits, _ := io.ReadAll(zr)
+	if len(bits) != statusListSize/8 {
+		t.Fatalf("expected a %d byte list, got %d", statusListSize/8, len(bits))
+	}
+	if bits[0] != 0x80 || bits[1] != 0x08 || bytes.Count(bits[2:], []byte{0}) != len(bits)-2 {
+		t.Errorf("expected bits 0 and 12 set, got %x", bits[:2])
+	}
+}
@@ -0,0 +1,603 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	crowdZonePrefix   = "sih:crowd:zone:"
+	crowdLevelPrefix  = "sih:crowd:level:"
+	crowdSource       = "crowd"
+	maxCrowdEstimates = 500
+	// maxCrowdDensity is well past the crush densities crowd analytics report, in people per m²
+	maxCrowdDensity = 10
+	// maxCrowdClockSkew is how far ahead of the gateway's clock an estimate may be timestamped
+	maxCrowdClockSkew = 5 * time.Minute
+
+	crowdNormal    = "normal"
+	crowdBusy      = "busy"
+	crowdSaturated = "saturated"
+)
+
+var crowdLevels = []string{crowdNormal, crowdBusy, crowdSaturated}
+
+// CrowdEstimate is one reading from a telecom or camera analytics provider. It names the zone it
+// covers, or the point it was taken at, such as a cell site or a camera, which is matched to the
+// zones containing it. Count is people seen; Density is people per m², as camera analytics report.
+type CrowdEstimate struct {
+	SensorID   string   `json:"sensorId" binding:"required"`
+	ZoneID     string   `json:"zoneId"`
+	Latitude   *float64 `json:"latitude"`
+	Longitude  *float64 `json:"longitude"`
+	Count      *int     `json:"count"`
+	Density    *float64 `json:"density"`
+	ObservedAt string   `json:"observedAt" binding:"required"`
+}
+
+// CrowdIngestRequest is a batch of estimates from one provider
+type CrowdIngestRequest struct {
+	Source    string          `json:"source" binding:"required"`
+	Actor     string          `json:"actor" binding:"required"`
+	Estimates []CrowdEstimate `json:"estimates" binding:"required"`
+}
+
+func (r CrowdIngestRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.identifier("source", r.Source)
+	v.identifier("actor", r.Actor)
+	if len(r.Estimates) == 0 || len(r.Estimates) > maxCrowdEstimates {
+		v.add("estimates", "must contain between 1 and %d estimates", maxCrowdEstimates)
+	}
+	for i, e := range r.Estimates {
+		field := func(name string) string { return fmt.Sprintf("estimates[%d].%s", i, name) }
+		v.identifier(field("sensorId"), e.SensorID)
+		if e.ZoneID != "" {
+			v.identifier(field("zoneId"), e.ZoneID)
+		} else {
+			if e.Latitude == nil || *e.Latitude < -90 || *e.Latitude > 90 {
+				v.add(field("latitude"), "must be between -90 and 90 when zoneId is not given")
+			}
+			if e.Longitude == nil || *e.Longitude < -180 || *e.Longitude > 180 {
+				v.add(field("longitude"), "must be between -180 and 180 when zoneId is not given")
+			}
+		}
+		if e.Count == nil && e.Density == nil {
+			v.add(field("count"), "or density is required")
+		}
+		if e.Count != nil && *e.Count < 0 {
+			v.add(field("count"), "must not be negative")
+		}
+		if e.Density != nil && (*e.Density < 0 || *e.Density > maxCrowdDensity) {
+			v.add(field("density"), "must be between 0 and %d people per square metre", maxCrowdDensity)
+		}
+		if at, ok := v.rfc3339(field("observedAt"), e.ObservedAt); ok && at.After(time.Now().Add(maxCrowdClockSkew)) {
+			v.add(field("observedAt"), "must not be in the future")
+		}
+	}
+	return v.errors
+}
+
+// crowdReading is a sensor's latest estimate for one zone
+type crowdReading struct {
+	Source     string   `json:"source"`
+	SensorID   string   `json:"sensor_id"`
+	Count      *int     `json:"count,omitempty"`
+	Density    *float64 `json:"density,omitempty"`
+	ObservedAt string   `json:"observed_at"`
+}
+
+func (r crowdReading) key() string {
+	return r.Source + "/" + r.SensorID
+}
+
+// CrowdZone is a zone's crowd level from the fresh readings of its sensors. Occupancy is the
+// fraction of capacity in use, from the people counted or the densest reading, whichever is higher.
+type CrowdZone struct {
+	ZoneID    string  `json:"zone_id"`
+	Name      string  `json:"name"`
+	Level     string  `json:"level"`
+	Count     int     `json:"count"`
+	Density   float64 `json:"density,omitempty"`
+	Capacity  int     `json:"capacity"`
+	Occupancy float64 `json:"occupancy"`
+	Sensors   int     `json:"sensors"`
+	UpdatedAt string  `json:"updated_at,omitempty"`
+}
+
+// CrowdAdvisory is an overcrowding advisory raised when a zone saturates. Its digest is anchored
+// with AnchorAdvisory under source "crowd".
+type CrowdAdvisory struct {
+	AdvisoryID      string  `json:"advisory_id"`
+	ZoneID          string  `json:"zone_id"`
+	Occupancy       float64 `json:"occupancy"`
+	IssuedAt        string  `json:"issued_at"`
+	ExpiresAt       string  `json:"expires_at"`
+	Digest          string  `json:"digest"`
+	AnchorTxID      string  `json:"anchor_tx_id,omitempty"`
+	NotifiedInside  int     `json:"notified_inside"`
+	NotifiedHeading int     `json:"notified_heading"`
+}
+
+// CrowdIngestion reports what a batch of estimates did
+type CrowdIngestion struct {
+	Accepted   int             `json:"accepted"`
+	Ignored    []CrowdIgnored  `json:"ignored"`
+	Zones      []CrowdZone     `json:"zones"`
+	Advisories []CrowdAdvisory `json:"advisories"`
+}
+
+// CrowdIgnored is an estimate that changed nothing, by its position in the batch
+type CrowdIgnored struct {
+	Index  int    `json:"index"`
+	Reason string `json:"reason"`
+}
+
+// CrowdListRequest filters the crowd list to zones at or above a level
+type CrowdListRequest struct {
+	MinLevel string `form:"min_level"`
+}
+
+func (r CrowdListRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	if r.MinLevel != "" {
+		v.oneOf("min_level", r.MinLevel, crowdLevels)
+	}
+	return v.errors
+}
+
+// crowdStore keeps each zone's readings by sensor, and the level it was last reported at. It uses
+// Redis when the document cache is configured, so estimates may reach any gateway instance.
+type crowdStore interface {
+	readings(ctx context.Context, zoneID string) (map[string]crowdReading, error)
+	record(ctx context.Context, zoneID string, reading crowdReading) error
+	// swapLevel stores a zone's level and returns the one it replaces
+	swapLevel(ctx context.Context, zoneID, level string) (string, error)
+}
+
+// crowdMonitor correlates crowd estimates with geofence zones and raises overcrowding advisories to
+// the tourists inside a saturated zone or with it on their itinerary
+type crowdMonitor struct {
+	store            crowdStore
+	estimateTTL      time.Duration
+	capacities       map[string]int
+	busyRatio        float64
+	saturatedDensity float64
+	headingHorizon   time.Duration
+	advisoryTTL      time.Duration
+	cooldown         time.Duration
+}
+
+var crowds *crowdMonitor
+
+// initCrowds runs after initGeofence. CROWD_ZONE_CAPACITY is a list of zone=people pairs; other
+// zones hold CROWD_SATURATED_DENSITY people per m² of their area.
+func initCrowds() {
+	ttl := getEnvDuration("CROWD_ESTIMATE_TTL", 15*time.Minute)
+	var store crowdStore = newMemoryCrowdStore(ttl)
+	backend := "memory"
+	if documentCache != nil {
+		store, backend = redisCrowdStore{documentCache, ttl}, "Redis"
+	}
+	capacities, err := parseCrowdCapacities(getEnv("CROWD_ZONE_CAPACITY", ""))
+	if err != nil {
+		panic(fmt.Errorf("CROWD_ZONE_CAPACITY %w", err))
+	}
+	m := &crowdMonitor{
+		store:            store,
+		estimateTTL:      ttl,
+		capacities:       capacities,
+		busyRatio:        getEnvFloat("CROWD_BUSY_RATIO", 0.75),
+		saturatedDensity: getEnvFloat("CROWD_SATURATED_DENSITY", 4),
+		headingHorizon:   getEnvDuration("CROWD_HEADING_HORIZON", 2*time.Hour),
+		advisoryTTL:      getEnvDuration("CROWD_ADVISORY_TTL", time.Hour),
+		cooldown:         getEnvDuration("CROWD_ADVISORY_COOLDOWN", 30*time.Minute),
+	}
+	if m.busyRatio <= 0 || m.busyRatio >= 1 {
+		panic(errors.New("CROWD_BUSY_RATIO must be between 0 and 1"))
+	}
+	if m.saturatedDensity <= 0 || m.saturatedDensity > maxCrowdDensity {
+		panic(fmt.Errorf("CROWD_SATURATED_DENSITY must be between 0 and %d", maxCrowdDensity))
+	}
+	crowds = m
+	log.Printf("👥 Keeping crowd estimates in %s for %s, %d zones with set capacities", backend, ttl, len(capacities))
+}
+
+func parseCrowdCapacities(value string) (map[string]int, error) {
+	capacities := map[string]int{}
+	for _, item := range splitList(value) {
+		zoneID, people, _ := strings.Cut(item, "=")
+		zoneID = strings.TrimSpace(zoneID)
+		if !identifierRegex.MatchString(zoneID) {
+			return nil, fmt.Errorf("has invalid zone ID %q", zoneID)
+		}
+		capacity, err := strconv.Atoi(strings.TrimSpace(people))
+		if err != nil || capacity <= 0 {
+			return nil, fmt.Errorf("has invalid capacity for %s", zoneID)
+		}
+		capacities[zoneID] = capacity
+	}
+	return capacities, nil
+}
+
+// zone returns an indexed zone by ID
+func (g *geofenceIndex) zone(zoneID string) (*indexedZone, bool) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	z, ok := g.zones[zoneID]
+	return z, ok
+}
+
+// capacity is how many people a zone holds before it saturates
+func (m *crowdMonitor) capacity(z *indexedZone) int {
+	if capacity, ok := m.capacities[z.zone.ZoneID]; ok {
+		return capacity
+	}
+	return max(int(z.areaKm2()*1e6*m.saturatedDensity), 1)
+}
+
+// level sums each source's fresh readings, and takes the highest source, since a telecom operator
+// and a camera network over the same zone count the same crowd
+func (m *crowdMonitor) level(z *indexedZone, readings map[string]crowdReading, now time.Time) CrowdZone {
+	crowd := CrowdZone{ZoneID: z.zone.ZoneID, Name: z.zone.Name, Level: crowdNormal, Capacity: m.capacity(z)}
+	counts := map[string]int{}
+	var updated time.Time
+	for _, reading := range readings {
+		observed, _ := time.Parse(time.RFC3339, reading.ObservedAt)
+		if now.Sub(observed) > m.estimateTTL {
+			continue
+		}
+		crowd.Sensors++
+		if reading.Count != nil {
+			counts[reading.Source] += *reading.Count
+		}
+		if reading.Density != nil {
+			crowd.Density = math.Max(crowd.Density, *reading.Density)
+		}
+		if observed.After(updated) {
+			updated = observed
+		}
+	}
+	for _, count := range counts {
+		crowd.Count = max(crowd.Count, count)
+	}
+	if crowd.Sensors == 0 {
+		return crowd
+	}
+	crowd.UpdatedAt = updated.UTC().Format(time.RFC3339)
+	occupancy := math.Max(float64(crowd.Count)/float64(crowd.Capacity), crowd.Density/m.saturatedDensity)
+	crowd.Occupancy = math.Round(occupancy*100) / 100
+	switch {
+	case occupancy >= 1:
+		crowd.Level = crowdSaturated
+	case occupancy >= m.busyRatio:
+		crowd.Level = crowdBusy
+	}
+	return crowd
+}
+
+// zonesFor returns the zones an estimate covers
+func (m *crowdMonitor) zonesFor(e CrowdEstimate, now time.Time) []*indexedZone {
+	if e.ZoneID != "" {
+		if z, ok := geofence.zone(e.ZoneID); ok {
+			return []*indexedZone{z}
+		}
+		return nil
+	}
+	var zones []*indexedZone
+	for _, match := range geofence.evaluate(*e.Latitude, *e.Longitude, now).Zones {
+		if z, ok := geofence.zone(match.ZoneID); ok {
+			zones = append(zones, z)
+		}
+	}
+	return zones
+}
+
+// ingest records each estimate against the zones it covers, unless the sensor already reported a
+// later one, then reports the zones' levels and raises an advisory for each zone that saturated
+func (m *crowdMonitor) ingest(ctx context.Context, req CrowdIngestRequest, now time.Time) (CrowdIngestion, error) {
+	result := CrowdIngestion{Ignored: []CrowdIgnored{}, Zones: []CrowdZone{}, Advisories: []CrowdAdvisory{}}
+	touched := map[string]*indexedZone{}
+	readings := map[string]map[string]crowdReading{}
+	for i, e := range req.Estimates {
+		observed, _ := time.Parse(time.RFC3339, e.ObservedAt)
+		if now.Sub(observed) > m.estimateTTL {
+			result.Ignored = append(result.Ignored, CrowdIgnored{Index: i, Reason: "older than CROWD_ESTIMATE_TTL"})
+			continue
+		}
+		zones := m.zonesFor(e, now)
+		if len(zones) == 0 {
+			result.Ignored = append(result.Ignored, CrowdIgnored{Index: i, Reason: "not inside any zone"})
+			continue
+		}
+		reading := crowdReading{Source: req.Source, SensorID: e.SensorID, Count: e.Count, Density: e.Density, ObservedAt: observed.UTC().Format(time.RFC3339)}
+		recorded := false
+		for _, z := range zones {
+			zoneID := z.zone.ZoneID
+			if readings[zoneID] == nil {
+				stored, err := m.store.readings(ctx, zoneID)
+				if err != nil {
+					return result, err
+				}
+				readings[zoneID] = stored
+			}
+			if last, ok := readings[zoneID][reading.key()]; ok && last.ObservedAt >= reading.ObservedAt {
+				continue
+			}
+			if err := m.store.record(ctx, zoneID, reading); err != nil {
+				return result, err
+			}
+			readings[zoneID][reading.key()], touched[zoneID], recorded = reading, z, true
+		}
+		if !recorded {
+			result.Ignored = append(result.Ignored, CrowdIgnored{Index: i, Reason: "the sensor already reported a later estimate"})
+			continue
+		}
+		result.Accepted++
+	}
+
+	for _, zoneID := range sortedKeys(touched) {
+		crowd := m.level(touched[zoneID], readings[zoneID], now)
+		result.Zones = append(result.Zones, crowd)
+		previous, err := m.store.swapLevel(ctx, zoneID, crowd.Level)
+		if err != nil {
+			return result, err
+		}
+		if crowd.Level != crowdSaturated || previous == crowdSaturated {
+			continue
+		}
+		if first, err := claimEvent(ctx, "crowd-advisory:"+zoneID, m.cooldown); err != nil || !first {
+			continue
+		}
+		result.Advisories = append(result.Advisories, m.advise(ctx, touched[zoneID], crowd, req.Actor, now))
+	}
+	return result, nil
+}
+
+// advise anchors an overcrowding advisory for a saturated zone and pushes it to the tourists inside
+// the zone and those heading there. When anchoring fails, the tourists are still told.
+func (m *crowdMonitor) advise(ctx context.Context, z *indexedZone, crowd CrowdZone, actor string, now time.Time) CrowdAdvisory {
+	advisory := CrowdAdvisory{
+		AdvisoryID: fmt.Sprintf("CROWD-%s-%d", crowd.ZoneID, now.Unix()),
+		ZoneID:     crowd.ZoneID,
+		Occupancy:  crowd.Occupancy,
+		IssuedAt:   now.UTC().Format(time.RFC3339),
+		ExpiresAt:  now.Add(m.advisoryTTL).UTC().Format(time.RFC3339),
+	}
+	data, _ := json.Marshal(crowd)
+	sum := sha256.Sum256(data)
+	advisory.Digest = hex.EncodeToString(sum[:])
+
+	zoneIDs, _ := json.Marshal([]string{crowd.ZoneID})
+	result, err := submitTransaction(ctx, "AnchorAdvisory", advisoryAnchorPrefix+advisory.Digest, advisory.AdvisoryID, crowdSource, advisory.Digest, crowdSaturated, string(zoneIDs), advisory.IssuedAt, advisory.ExpiresAt, actor)
+	if err != nil {
+		logWithContext(ctx, "👥 Failed to anchor crowd advisory %s: %v", advisory.AdvisoryID, err)
+	} else {
+		advisory.AnchorTxID = result.TxID
+	}
+
+	if pusher == nil {
+		return advisory
+	}
+	inside, heading := m.audience(ctx, z, now)
+	advisory.NotifiedInside, advisory.NotifiedHeading = len(inside), len(heading)
+	pushData := func(audience string) map[string]string {
+		return map[string]string{"type": "crowd", "advisory_id": advisory.AdvisoryID, "zone_id": crowd.ZoneID, "level": crowd.Level, "audience": audience, "expires_at": advisory.ExpiresAt}
+	}
+	if len(inside) > 0 {
+		go pusher.deliver(context.WithoutCancel(ctx), pushAlert{
+			key:      "crowd:" + advisory.AdvisoryID + ":inside",
+			tourists: inside,
+			title:    "Overcrowding at " + crowd.Name,
+			body:     "This area is over capacity. Keep to open spaces and follow the marshals' directions.",
+			data:     pushData("inside"),
+		})
+	}
+	if len(heading) > 0 {
+		go pusher.deliver(context.WithoutCancel(ctx), pushAlert{
+			key:      "crowd:" + advisory.AdvisoryID + ":heading",
+			tourists: heading,
+			title:    "Overcrowding ahead at " + crowd.Name,
+			body:     crowd.Name + " on your itinerary is over capacity. Consider visiting later.",
+			data:     pushData("heading"),
+		})
+	}
+	return advisory
+}
+
+// audience returns the tourists inside a zone at their last location ping, and the others whose
+// itinerary reaches it within CROWD_HEADING_HORIZON
+func (m *crowdMonitor) audience(ctx context.Context, z *indexedZone, now time.Time) (inside, heading map[string]bool) {
+	inside, heading = map[string]bool{}, map[string]bool{}
+	if zoneTracker != nil {
+		occupants, err := zoneTracker.store.occupants(ctx, z.zone.ZoneID)
+		if err != nil {
+			logWithContext(ctx, "👥 Failed to list tourists in zone %s: %v", z.zone.ZoneID, err)
+		}
+		for _, digitalID := range occupants {
+			inside[digitalID] = true
+		}
+	}
+	if anomalies == nil {
+		return inside, heading
+	}
+	itineraries, err := anomalies.itineraries.list(ctx)
+	if err != nil {
+		logWithContext(ctx, "👥 Failed to list itineraries: %v", err)
+		return inside, heading
+	}
+	contains := func(lat, lng float64) bool { return z.contains(lng, lat) }
+	for _, it := range itineraries {
+		if inside[it.DigitalID] {
+			continue
+		}
+		if _, ok := it.upcomingIn(contains, now, m.headingHorizon); ok {
+			heading[it.DigitalID] = true
+		}
+	}
+	return inside, heading
+}
+
+// redisCrowdStore keeps a hash of readings per zone, which expires when no sensor reports for
+// CROWD_ESTIMATE_TTL
+type redisCrowdStore struct {
+	redis *redisClient
+	ttl   time.Duration
+}
+
+func (s redisCrowdStore) readings(ctx context.Context, zoneID string) (map[string]crowdReading, error) {
+	return redisHashJSON[crowdReading](ctx, s.redis, crowdZonePrefix+zoneID)
+}
+
+func (s redisCrowdStore) record(ctx context.Context, zoneID string, reading crowdReading) error {
+	data, err := json.Marshal(reading)
+	if err != nil {
+		return err
+	}
+	key := crowdZonePrefix + zoneID
+	if _, err := s.redis.Do(ctx, "HSET", key, reading.key(), string(data)); err != nil {
+		return err
+	}
+	_, err = s.redis.Do(ctx, "PEXPIRE", key, strconv.FormatInt(s.ttl.Milliseconds(), 10))
+	return err
+}
+
+// swapLevel uses SET with GET, so of several instances seeing a zone saturate only one sees the change
+func (s redisCrowdStore) swapLevel(ctx context.Context, zoneID, level string) (string, error) {
+	reply, err := s.redis.Do(ctx, "SET", crowdLevelPrefix+zoneID, level, "GET", "PX", strconv.FormatInt(s.ttl.Milliseconds(), 10))
+	if errors.Is(err, errRedisNil) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	previous, _ := reply.([]byte)
+	return string(previous), nil
+}
+
+// memoryCrowdStore serves a single gateway instance
+type memoryCrowdStore struct {
+	ttl time.Duration
+
+	mu       sync.Mutex
+	zones    map[string]map[string]crowdReading
+	levels   map[string]string
+	reported map[string]time.Time
+}
+
+func newMemoryCrowdStore(ttl time.Duration) *memoryCrowdStore {
+	return &memoryCrowdStore{ttl: ttl, zones: map[string]map[string]crowdReading{}, levels: map[string]string{}, reported: map[string]time.Time{}}
+}
+
+func (s *memoryCrowdStore) readings(_ context.Context, zoneID string) (map[string]crowdReading, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	readings := map[string]crowdReading{}
+	if time.Since(s.reported[zoneID]) > s.ttl {
+		return readings, nil
+	}
+	for key, reading := range s.zones[zoneID] {
+		readings[key] = reading
+	}
+	return readings, nil
+}
+
+func (s *memoryCrowdStore) record(_ context.Context, zoneID string, reading crowdReading) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.zones[zoneID] == nil || time.Since(s.reported[zoneID]) > s.ttl {
+		s.zones[zoneID] = map[string]crowdReading{}
+	}
+	s.zones[zoneID][reading.key()], s.reported[zoneID] = reading, time.Now()
+	return nil
+}
+
+func (s *memoryCrowdStore) swapLevel(_ context.Context, zoneID, level string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	previous := s.levels[zoneID]
+	if time.Since(s.reported[zoneID]) > s.ttl {
+		previous = ""
+	}
+	s.levels[zoneID] = level
+	return previous, nil
+}
+
+// ingestCrowdEstimates accepts a batch of estimates pushed by a telecom or camera analytics provider
+func ingestCrowdEstimates(c *gin.Context) {
+	var req CrowdIngestRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+	setAuditTarget(c, req.Source)
+	result, err := crowds.ingest(c.Request.Context(), req, time.Now())
+	if err != nil {
+		respondServiceError(c, "Failed to ingest crowd estimates", err)
+		return
+	}
+	respondData(c, http.StatusOK, result)
+}
+
+// listCrowdZones reports every zone with fresh estimates, most crowded first
+func listCrowdZones(c *gin.Context) {
+	var req CrowdListRequest
+	if !bindQuery(c, &req) {
+		return
+	}
+	ctx, now := c.Request.Context(), time.Now()
+	minLevel := max(slices.Index(crowdLevels, req.MinLevel), 0)
+	zones := []CrowdZone{}
+	for _, z := range geofence.snapshot() {
+		readings, err := crowds.store.readings(ctx, z.zone.ZoneID)
+		if err != nil {
+			respondServiceError(c, "Failed to read crowd estimates", err)
+			return
+		}
+		crowd := crowds.level(z, readings, now)
+		if crowd.Sensors == 0 || slices.Index(crowdLevels, crowd.Level) < minLevel {
+			continue
+		}
+		zones = append(zones, crowd)
+	}
+	sort.SliceStable(zones, func(i, j int) bool { return zones[i].Occupancy > zones[j].Occupancy })
+	respondData(c, http.StatusOK, zones)
+}
+
+func getCrowdZone(c *gin.Context) {
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	z, ok := geofence.zone(id)
+	if !ok {
+		respondError(c, http.StatusNotFound, errCodeNotFound, "No geofence zone with this ID")
+		return
+	}
+	readings, err := crowds.store.readings(c.Request.Context(), id)
+	if err != nil {
+		respondServiceError(c, "Failed to read crowd estimates", err)
+		return
+	}
+	respondData(c, http.StatusOK, crowds.level(z, readings, time.Now()))
+}
@@ -0,0 +1,154 @@
+package gateway
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestCrowdIngest(t *testing.T) {
+	previous := geofence
+	geofence = newGeofenceIndex()
+	defer func() { geofence = previous }()
+	square := `{"type":"Polygon","coordinates":[[[91.81,25.53],[91.83,25.53],[91.83,25.55],[91.81,25.55],[91.81,25.53]]]}`
+	for _, doc := range []ZoneDocument{
+		{ZoneID: "police-bazar", Name: "Police Bazar", Geometry: square, RiskLevel: "low"},
+		{ZoneID: "falls", Name: "Elephant Falls", Geometry: `{"type":"Circle","coordinates":[91.82,25.54],"radius":100}`, RiskLevel: "medium"},
+	} {
+		z, err := newIndexedZone(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		geofence.put(z)
+	}
+
+	m := &crowdMonitor{store: newMemoryCrowdStore(15 * time.Minute), estimateTTL: 15 * time.Minute, capacities: map[string]int{"police-bazar": 1000}, busyRatio: 0.75, saturatedDensity: 4}
+	ctx, now := context.Background(), time.Now().UTC().Truncate(time.Second)
+	at := func(ago time.Duration) string { return now.Add(-ago).Format(time.RFC3339) }
+	count := func(n int) *int { return &n }
+	lat, lng, outside := 25.54, 91.82, 25.60
+
+	result, err := m.ingest(ctx, CrowdIngestRequest{Source: "airtel", Actor: "crowd-feed", Estimates: []CrowdEstimate{
+		{SensorID: "cell-1", Latitude: &lat, Longitude: &lng, Count: count(500), ObservedAt: at(time.Minute)},
+		{SensorID: "cell-2", ZoneID: "police-bazar", Count: count(300), ObservedAt: at(time.Minute)},
+		{SensorID: "cell-3", Latitude: &outside, Longitude: &lng, Count: count(900), ObservedAt: at(time.Minute)},
+		{SensorID: "cell-4", ZoneID: "police-bazar", Count: count(900), ObservedAt: at(time.Hour)},
+	}}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The cell site inside both zones counts towards each; the others are outside every zone or stale
+	if result.Accepted != 2 || len(result.Ignored) != 2 || result.Ignored[0].Index != 2 || result.Ignored[1].Index != 3 {
+		t.Errorf("unexpected ingestion %+v", result)
+	}
+	if len(result.Zones) != 2 || result.Zones[1].ZoneID != "police-bazar" || result.Zones[1].Count != 800 || result.Zones[1].Level != crowdBusy || result.Zones[1].Occupancy != 0.8 {
+		t.Errorf("expected Police Bazar busy at 800 of 1000, got %+v", result.Zones)
+	}
+	if falls := result.Zones[0]; falls.Level != crowdNormal || falls.Capacity != 125663 || len(result.Advisories) != 0 {
+		t.Errorf("expected Elephant Falls sized by its area and normal, got %+v", falls)
+	}
+
+	// A camera over the same square counts the same crowd: the higher source stands, not the sum
+	result, _ = m.ingest(ctx, CrowdIngestRequest{Source: "cctv", Actor: "crowd-feed", Estimates: []CrowdEstimate{
+		{SensorID: "cam-1", ZoneID: "police-bazar", Count: count(600), ObservedAt: at(0)},
+	}}, now)
+	if crowd := result.Zones[0]; crowd.Count != 800 || crowd.Sensors != 3 {
+		t.Errorf("expected the busiest source kept, got %+v", crowd)
+	}
+
+	// A sensor's older estimate arriving late changes nothing
+	result, _ = m.ingest(ctx, CrowdIngestRequest{Source: "airtel", Actor: "crowd-feed", Estimates: []CrowdEstimate{
+		{SensorID: "cell-2", ZoneID: "police-bazar", Count: count(5000), ObservedAt: at(2 * time.Minute)},
+	}}, now)
+	if result.Accepted != 0 || len(result.Zones) != 0 || len(result.Ignored) != 1 {
+		t.Errorf("expected the late estimate ignored, got %+v", result)
+	}
+	if previous, _ := m.store.swapLevel(ctx, "police-bazar", crowdBusy); previous != crowdBusy {
+		t.Errorf("expected the level recorded, got %q", previous)
+	}
+}
+
+func TestCrowdLevel(t *testing.T) {
+	z, err := newIndexedZone(ZoneDocument{ZoneID: "ghat", Name: "Ghat", Geometry: `{"type":"Circle","coordinates":[91.82,25.54],"radius":10}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &crowdMonitor{estimateTTL: 15 * time.Minute, capacities: map[string]int{}, busyRatio: 0.75, saturatedDensity: 4}
+	now := time.Now().UTC()
+	density := 4.2
+	readings := map[string]crowdReading{
+		"cctv/cam-1": {Source: "cctv", SensorID: "cam-1", Density: &density, ObservedAt: now.Format(time.RFC3339)},
+	}
+	// Dense camera readings saturate a zone whatever the head count
+	if crowd := m.level(z, readings, now); crowd.Level != crowdSaturated || crowd.Occupancy != 1.05 || crowd.UpdatedAt == "" {
+		t.Errorf("expected the zone saturated by density, got %+v", crowd)
+	}
+	if crowd := m.level(z, readings, now.Add(time.Hour)); crowd.Level != crowdNormal || crowd.Sensors != 0 {
+		t.Errorf("expected stale readings dropped, got %+v", crowd)
+	}
+}
+
+func TestCrowdAudience(t *testing.T) {
+	previousTracker, previousAnomalies := zoneTracker, anomalies
+	defer func() { zoneTracker, anomalies = previousTracker, previousAnomalies }()
+	z, err := newIndexedZone(ZoneDocument{ZoneID: "falls", Name: "Elephant Falls", Geometry: `{"type":"Circle","coordinates":[91.82,25.54],"radius":500}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, now := context.Background(), time.Now().UTC().Truncate(time.Second)
+	zoneTracker = &geofenceTracker{store: newMemoryPresenceStore(time.Hour)}
+	zoneTracker.store.save(ctx, "did:sih:inside", zonePresence{Zones: map[string]zoneVisit{"falls": {Name: "Elephant Falls"}}})
+	anomalies = &anomalyDetector{itineraries: newMemoryItineraryStore()}
+
+	stop := func(lat, lng float64, arrive time.Duration) ItineraryStop {
+		s := ItineraryStop{Latitude: &lat, Longitude: &lng}
+		if arrive != 0 {
+			s.ArriveAt, s.DepartAt = now.Add(arrive).Format(time.RFC3339), now.Add(arrive+time.Hour).Format(time.RFC3339)
+		}
+		return s
+	}
+	declare := func(digitalID string, stops ...ItineraryStop) {
+		anomalies.itineraries.put(ctx, Itinerary{DigitalID: digitalID, Stops: stops, StartsAt: now.Add(-time.Hour).Format(time.RFC3339), EndsAt: now.Add(24 * time.Hour).Format(time.RFC3339)}, time.Hour)
+	}
+	declare("did:sih:inside", stop(25.54, 91.82, 0), stop(25.60, 91.90, 0))
+	declare("did:sih:soon", stop(25.57, 91.88, 0), stop(25.54, 91.82, time.Hour))
+	declare("did:sih:tomorrow", stop(25.57, 91.88, 0), stop(25.54, 91.82, 20*time.Hour))
+	declare("did:sih:elsewhere", stop(25.57, 91.88, 0), stop(25.60, 91.90, 0))
+
+	m := &crowdMonitor{headingHorizon: 2 * time.Hour}
+	inside, heading := m.audience(ctx, z, now)
+	if len(inside) != 1 || !inside["did:sih:inside"] {
+		t.Errorf("expected the tourist inside addressed as inside, got %v", inside)
+	}
+	if names := sortedKeys(heading); !slices.Equal(names, []string{"did:sih:soon"}) {
+		t.Errorf("expected only the tourist due within the horizon heading there, got %v", names)
+	}
+}
+
+func TestCrowdValidation(t *testing.T) {
+	count, density, lat := 10, 12.0, 25.5
+	cases := []struct {
+		estimate CrowdEstimate
+		field    string
+	}{
+		{CrowdEstimate{SensorID: "cam-1", ZoneID: "falls", ObservedAt: "2025-09-20T13:00:00Z"}, "estimates[0].count"},
+		{CrowdEstimate{SensorID: "cam-1", Latitude: &lat, Count: &count, ObservedAt: "2025-09-20T13:00:00Z"}, "estimates[0].longitude"},
+		{CrowdEstimate{SensorID: "cam-1", ZoneID: "falls", Density: &density, ObservedAt: "2025-09-20T13:00:00Z"}, "estimates[0].density"},
+		{CrowdEstimate{SensorID: "cam-1", ZoneID: "falls", Count: &count, ObservedAt: time.Now().Add(time.Hour).Format(time.RFC3339)}, "estimates[0].observedAt"},
+	}
+	for _, tc := range cases {
+		errs := CrowdIngestRequest{Source: "cctv", Actor: "crowd-feed", Estimates: []CrowdEstimate{tc.estimate}}.Validate()
+		if len(errs) != 1 || errs[0].Field != tc.field {
+			t.Errorf("expected %s rejected, got %v", tc.field, errs)
+		}
+	}
+	if _, err := parseCrowdCapacities("police-bazar=5000, falls=800"); err != nil {
+		t.Error(err)
+	}
+	for _, value := range []string{"falls", "falls=0", "falls=many"} {
+		if _, err := parseCrowdCapacities(value); err == nil {
+			t.Errorf("expected %q rejected", value)
+		}
+	}
+}
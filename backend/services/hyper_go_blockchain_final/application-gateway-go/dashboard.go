@@ -0,0 +1,605 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+const (
+	// unassignedDistrict holds open incidents without a location or with no district nearby
+	unassignedDistrict = "unassigned"
+
+	maxDashboardWait        = 30 * time.Second
+	defaultClusterRadiusKm  = 1.0
+	maxClusterRadiusKm      = 50.0
+	defaultClusterWindow    = 2 * time.Hour
+	defaultClusterSpan      = 24 * time.Hour
+	maxClusterPoints        = 5000
+	maxDistrictIncidents    = 200
+	defaultDashboardResync  = 5 * time.Minute
+	dashboardResyncAttempts = 30 * time.Second
+)
+
+// DistrictCount is the open incidents in one district
+type DistrictCount struct {
+	District   string         `json:"district"`
+	Open       int            `json:"open"`
+	BySeverity map[string]int `json:"by_severity"`
+}
+
+// LiveCounts is the open incident board. Pass Version as since to wait for the next change.
+type LiveCounts struct {
+	GeneratedAt string `json:"generated_at"`
+	// Source is index once the board has been loaded from the off-chain index, and events while it
+	// only holds the incidents seen since the gateway started
+	Source    string          `json:"source"`
+	Version   uint64          `json:"version"`
+	Open      int             `json:"open"`
+	Districts []DistrictCount `json:"districts"`
+}
+
+type LiveCountsRequest struct {
+	Since uint64 `form:"since"`
+	// Wait is how long to hold the request open for a change, in seconds
+	Wait int `form:"wait"`
+}
+
+func (r LiveCountsRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	if r.Wait < 0 || time.Duration(r.Wait)*time.Second > maxDashboardWait {
+		v.add("wait", "must be between 1 and %d", int(maxDashboardWait/time.Second))
+	}
+	return v.errors
+}
+
+// ClusterRequest selects the located incidents to cluster. Incidents join a cluster when they are
+// within RadiusKm and WindowMinutes of any incident already in it.
+type ClusterRequest struct {
+	From          string  `form:"from"`
+	To            string  `form:"to"`
+	RadiusKm      float64 `form:"radius_km"`
+	WindowMinutes int     `form:"window_minutes"`
+	MinSize       int     `form:"min_size"`
+	District      string  `form:"district"`
+}
+
+func (r ClusterRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.timeRange(r.From, r.To)
+	if r.RadiusKm < 0 || r.RadiusKm > maxClusterRadiusKm {
+		v.add("radius_km", "must be between 0 and %g", maxClusterRadiusKm)
+	}
+	if r.WindowMinutes < 0 || r.WindowMinutes > 7*24*60 {
+		v.add("window_minutes", "must be between 1 and %d", 7*24*60)
+	}
+	if r.MinSize < 0 {
+		v.add("min_size", "must not be negative")
+	}
+	return v.errors
+}
+
+// IncidentCluster is a group of incidents close together in place and time. Its ID is the earliest
+// incident's, so a cluster keeps its ID while it grows.
+type IncidentCluster struct {
+	ClusterID   string         `json:"cluster_id"`
+	District    string         `json:"district"`
+	Latitude    float64        `json:"latitude"`
+	Longitude   float64        `json:"longitude"`
+	Count       int            `json:"count"`
+	Open        int            `json:"open"`
+	BySeverity  map[string]int `json:"by_severity"`
+	FirstAt     string         `json:"first_at"`
+	LastAt      string         `json:"last_at"`
+	IncidentIDs []string       `json:"incident_ids"`
+}
+
+// IncidentClusters answers a cluster query. Truncated is set when the range held more located
+// incidents than are clustered at once.
+type IncidentClusters struct {
+	From      string            `json:"from"`
+	To        string            `json:"to"`
+	RadiusKm  float64           `json:"radius_km"`
+	Window    string            `json:"window"`
+	Clusters  []IncidentCluster `json:"clusters"`
+	Truncated bool              `json:"truncated"`
+}
+
+// ResponderDistrict is one district's units and how many are in each roster status
+type ResponderDistrict struct {
+	District string         `json:"district"`
+	ByStatus map[string]int `json:"by_status"`
+	Units    []DispatchUnit `json:"units"`
+}
+
+// ResponderBoard is every unit from POLICE_UNITS_FILE grouped by district
+type ResponderBoard struct {
+	GeneratedAt string              `json:"generated_at"`
+	Districts   []ResponderDistrict `json:"districts"`
+}
+
+// DistrictDetail is the drill-down for one district: its open incidents, newest first, its units and
+// the clusters in it over the last day
+type DistrictDetail struct {
+	DistrictCount
+	Incidents []IncidentDocument `json:"incidents"`
+	Units     []DispatchUnit     `json:"units"`
+	Clusters  []IncidentCluster  `json:"clusters,omitempty"`
+}
+
+// boardIncident is an open incident on the live board
+type boardIncident struct {
+	IncidentDocument
+	district string
+}
+
+// incidentBoard keeps the open incidents of the default target in memory, applying chaincode events
+// as they arrive and reloading from the off-chain index so incidents opened before the gateway
+// started are counted too
+type incidentBoard struct {
+	mu      sync.Mutex
+	open    map[string]boardIncident
+	version uint64
+	notify  chan struct{}
+	loaded  bool
+	// touched holds the incidents events changed while a reload was reading the index, whose
+	// state on the board is newer than the index's
+	touched map[string]struct{}
+}
+
+var liveBoard = newIncidentBoard()
+
+func newIncidentBoard() *incidentBoard {
+	return &incidentBoard{open: map[string]boardIncident{}, notify: make(chan struct{})}
+}
+
+// districtOf places a geohash in the district of the nearest unit that names one
+func districtOf(geohash string) string {
+	cell, ok := geohashBounds(geohash)
+	if !ok {
+		return unassignedDistrict
+	}
+	unit, _ := nearestUnit(policeUnits, (cell.minLat+cell.maxLat)/2, (cell.minLng+cell.maxLng)/2, math.Inf(1), func(u *PoliceUnit) bool {
+		return u.District != ""
+	})
+	if unit == nil {
+		return unassignedDistrict
+	}
+	return unit.District
+}
+
+func incidentOpen(status string) bool {
+	return status != "resolved" && status != "closed"
+}
+
+// changed wakes the requests waiting on the board; callers hold b.mu
+func (b *incidentBoard) changed() {
+	b.version++
+	close(b.notify)
+	b.notify = make(chan struct{})
+}
+
+// apply records an incident's latest state, dropping it from the board once it is closed
+func (b *incidentBoard) apply(incident IncidentDocument) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.touched != nil {
+		b.touched[incident.IncidentID] = struct{}{}
+	}
+	if !incidentOpen(incident.Status) {
+		if _, ok := b.open[incident.IncidentID]; ok {
+			delete(b.open, incident.IncidentID)
+			b.changed()
+		}
+		return
+	}
+	b.open[incident.IncidentID] = boardIncident{IncidentDocument: incident, district: districtOf(incident.Geohash)}
+	b.changed()
+}
+
+// reload replaces the board with the index's open incidents, keeping the events applied meanwhile
+func (b *incidentBoard) reload(ctx context.Context, ix *offchainIndex) error {
+	b.mu.Lock()
+	b.touched = map[string]struct{}{}
+	b.mu.Unlock()
+
+	rows, err := ix.db.Query(ctx, fmt.Sprintf(`SELECT incident_id, incident_summary_hash, %s, reporter, status, severity, %s, %s, tx_id, category, subject_id, geohash, owner_org
+		FROM incidents WHERE deleted_at IS NULL AND status NOT IN ('resolved', 'closed')`,
+		utcColumn("created_at"), utcColumn("acknowledged_at"), utcColumn("resolved_at")))
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	touched := b.touched
+	b.touched = nil
+	if err != nil {
+		return err
+	}
+	open := make(map[string]boardIncident, len(rows))
+	for _, incident := range incidentRows(rows) {
+		if _, ok := touched[incident.IncidentID]; !ok {
+			open[incident.IncidentID] = boardIncident{IncidentDocument: incident, district: districtOf(incident.Geohash)}
+		}
+	}
+	for id := range touched {
+		if incident, ok := b.open[id]; ok {
+			open[id] = incident
+		}
+	}
+	b.open, b.loaded = open, true
+	b.changed()
+	return nil
+}
+
+// run reloads the board from the off-chain index every interval, retrying sooner after a failure
+func (b *incidentBoard) run(ctx context.Context, ix *offchainIndex, interval time.Duration) {
+	for {
+		wait := interval
+		if err := b.reload(ctx, ix); err != nil {
+			log.Printf("📊 Failed to load the incident board from the off-chain index: %v", err)
+			wait = min(interval, dashboardResyncAttempts)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// counts tallies the open incidents the tenant can see by district
+func (b *incidentBoard) counts(t tenant) LiveCounts {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	counts := LiveCounts{GeneratedAt: time.Now().UTC().Format(time.RFC3339), Source: "events", Version: b.version, Districts: []DistrictCount{}}
+	if b.loaded {
+		counts.Source = "index"
+	}
+	districts := map[string]*DistrictCount{}
+	for _, incident := range b.open {
+		if !t.owns(incident.OwnerOrg) {
+			continue
+		}
+		d := districts[incident.district]
+		if d == nil {
+			d = &DistrictCount{District: incident.district, BySeverity: map[string]int{}}
+			districts[incident.district] = d
+		}
+		d.Open++
+		d.BySeverity[incident.Severity]++
+		counts.Open++
+	}
+	for _, name := range sortedKeys(districts) {
+		counts.Districts = append(counts.Districts, *districts[name])
+	}
+	return counts
+}
+
+// wait returns the counts once the board moves past version, or when wait runs out
+func (b *incidentBoard) wait(ctx context.Context, t tenant, version uint64, wait time.Duration) (LiveCounts, error) {
+	b.mu.Lock()
+	current, notify := b.version, b.notify
+	b.mu.Unlock()
+	if version == current {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-notify:
+		case <-timer.C:
+		case <-ctx.Done():
+			return LiveCounts{}, ctx.Err()
+		}
+	}
+	return b.counts(t), nil
+}
+
+// snapshot returns every open incident on the board, whatever its organization
+func (b *incidentBoard) snapshot() []boardIncident {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	incidents := make([]boardIncident, 0, len(b.open))
+	for _, incident := range b.open {
+		incidents = append(incidents, incident)
+	}
+	return incidents
+}
+
+// district returns the tenant's open incidents in a district, newest first
+func (b *incidentBoard) district(t tenant, name string) (DistrictCount, []IncidentDocument) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	count := DistrictCount{District: name, BySeverity: map[string]int{}}
+	incidents := []IncidentDocument{}
+	for _, incident := range b.open {
+		if incident.district == name && t.owns(incident.OwnerOrg) {
+			count.Open++
+			count.BySeverity[incident.Severity]++
+			incidents = append(incidents, incident.IncidentDocument)
+		}
+	}
+	slices.SortFunc(incidents, func(a, b IncidentDocument) int {
+		return cmp.Or(strings.Compare(b.CreatedAt, a.CreatedAt), strings.Compare(b.IncidentID, a.IncidentID))
+	})
+	if len(incidents) > maxDistrictIncidents {
+		incidents = incidents[:maxDistrictIncidents]
+	}
+	return count, incidents
+}
+
+// dashboardFromEvent keeps the live board current with the default target's incident events
+func dashboardFromEvent(ctx context.Context, event *client.ChaincodeEvent) {
+	if !isDefaultTarget(ctx) {
+		return
+	}
+	switch event.EventName {
+	case "CreateIncident", "UpdateIncident", "UpdateIncidentStatus":
+		incident, err := decodeDocument[IncidentDocument](event.Payload, "incident event")
+		if err != nil {
+			return
+		}
+		liveBoard.apply(incident)
+	case "MergeIncidents":
+		incidents, err := decodeDocument[[]IncidentDocument](event.Payload, "incident merge event")
+		if err != nil {
+			return
+		}
+		for _, incident := range incidents {
+			liveBoard.apply(incident)
+		}
+	case "DeleteIncident":
+		incident, err := decodeDocument[IncidentDocument](event.Payload, "incident event")
+		if err != nil || incident.IncidentID == "" {
+			return
+		}
+		incident.Status = "closed"
+		liveBoard.apply(incident)
+	}
+}
+
+// clusterPoint is a located incident to cluster
+type clusterPoint struct {
+	incident IncidentDocument
+	lat, lng float64
+	at       time.Time
+}
+
+// clusterPoints groups points, sorted by time, that are chained together by pairs within radiusKm
+// and window of each other
+func clusterPoints(points []clusterPoint, radiusKm float64, window time.Duration) [][]clusterPoint {
+	parent := make([]int, len(points))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	for i := range points {
+		for j := i + 1; j < len(points) && points[j].at.Sub(points[i].at) <= window; j++ {
+			if haversineKm(points[i].lat, points[i].lng, points[j].lat, points[j].lng) <= radiusKm {
+				parent[find(j)] = find(i)
+			}
+		}
+	}
+
+	var groups [][]clusterPoint
+	index := map[int]int{}
+	for i, p := range points {
+		root := find(i)
+		g, ok := index[root]
+		if !ok {
+			g = len(groups)
+			index[root] = g
+			groups = append(groups, nil)
+		}
+		groups[g] = append(groups[g], p)
+	}
+	return groups
+}
+
+// newIncidentCluster summarizes a group of points in time order
+func newIncidentCluster(group []clusterPoint) IncidentCluster {
+	c := IncidentCluster{
+		ClusterID:   group[0].incident.IncidentID,
+		Count:       len(group),
+		BySeverity:  map[string]int{},
+		FirstAt:     group[0].incident.CreatedAt,
+		LastAt:      group[len(group)-1].incident.CreatedAt,
+		IncidentIDs: make([]string, 0, len(group)),
+	}
+	for _, p := range group {
+		c.Latitude += p.lat / float64(len(group))
+		c.Longitude += p.lng / float64(len(group))
+		c.BySeverity[p.incident.Severity]++
+		if incidentOpen(p.incident.Status) {
+			c.Open++
+		}
+		c.IncidentIDs = append(c.IncidentIDs, p.incident.IncidentID)
+	}
+	c.District = districtOf(encodeGeohash(c.Latitude, c.Longitude, ledgerGeohashPrecision))
+	return c
+}
+
+// ClusterIncidents clusters the located incidents created in the request's range, largest first
+func (ix *offchainIndex) ClusterIncidents(ctx context.Context, req ClusterRequest, t tenant) (IncidentClusters, error) {
+	now := time.Now().UTC()
+	result := IncidentClusters{From: req.From, To: req.To, RadiusKm: req.RadiusKm, Window: defaultClusterWindow.String()}
+	if result.To == "" {
+		result.To = now.Format(time.RFC3339)
+	}
+	if result.From == "" {
+		to, _ := time.Parse(time.RFC3339, result.To)
+		result.From = to.Add(-defaultClusterSpan).UTC().Format(time.RFC3339)
+	}
+	if result.RadiusKm == 0 {
+		result.RadiusKm = defaultClusterRadiusKm
+	}
+	window := defaultClusterWindow
+	if req.WindowMinutes > 0 {
+		window = time.Duration(req.WindowMinutes) * time.Minute
+		result.Window = window.String()
+	}
+	minSize := max(req.MinSize, 2)
+
+	var f queryFilter
+	f.add("deleted_at IS NULL AND geohash <> ''")
+	t.restrict(&f, "owner_org")
+	f.add("created_at >= ?", result.From)
+	f.add("created_at < ?", result.To)
+	rows, err := ix.db.Query(ctx, fmt.Sprintf(`SELECT incident_id, incident_summary_hash, %s, reporter, status, severity, %s, %s, tx_id, category, subject_id, geohash, owner_org
+		FROM incidents WHERE %s ORDER BY created_at, incident_id LIMIT %d`,
+		utcColumn("created_at"), utcColumn("acknowledged_at"), utcColumn("resolved_at"), f.where(), maxClusterPoints+1),
+		f.args...)
+	if err != nil {
+		return IncidentClusters{}, err
+	}
+	if len(rows) > maxClusterPoints {
+		rows, result.Truncated = rows[:maxClusterPoints], true
+	}
+
+	points := make([]clusterPoint, 0, len(rows))
+	for _, incident := range incidentRows(rows) {
+		cell, ok := geohashBounds(incident.Geohash)
+		at, err := time.Parse(time.RFC3339, incident.CreatedAt)
+		if !ok || err != nil {
+			continue
+		}
+		points = append(points, clusterPoint{incident: incident, lat: (cell.minLat + cell.maxLat) / 2, lng: (cell.minLng + cell.maxLng) / 2, at: at})
+	}
+	result.Clusters = []IncidentCluster{}
+	for _, group := range clusterPoints(points, result.RadiusKm, window) {
+		if len(group) < minSize {
+			continue
+		}
+		if c := newIncidentCluster(group); req.District == "" || c.District == req.District {
+			result.Clusters = append(result.Clusters, c)
+		}
+	}
+	slices.SortFunc(result.Clusters, func(a, b IncidentCluster) int {
+		return cmp.Or(b.Count-a.Count, strings.Compare(b.LastAt, a.LastAt))
+	})
+	return result, nil
+}
+
+func unitDistrict(unit PoliceUnit) string {
+	if unit.District == "" {
+		return unassignedDistrict
+	}
+	return unit.District
+}
+
+// getLiveCounts serves the open incident board, long-polling when since is the current version
+func getLiveCounts(c *gin.Context) {
+	var req LiveCountsRequest
+	if !bindQuery(c, &req) {
+		return
+	}
+	ctx := c.Request.Context()
+	t := tenantFromContext(ctx)
+	if req.Since == 0 {
+		respondData(c, http.StatusOK, liveBoard.counts(t))
+		return
+	}
+	wait := maxDashboardWait
+	if req.Wait > 0 {
+		wait = time.Duration(req.Wait) * time.Second
+	}
+	counts, err := liveBoard.wait(ctx, t, req.Since, wait)
+	if err != nil {
+		respondServiceError(c, "Failed to wait for incident counts", err)
+		return
+	}
+	respondData(c, http.StatusOK, counts)
+}
+
+func getIncidentClusters(c *gin.Context) {
+	var req ClusterRequest
+	if !bindQuery(c, &req) {
+		return
+	}
+	if offchain == nil {
+		respondError(c, http.StatusServiceUnavailable, errCodeIndexDisabled, "Incident clustering needs the off-chain index; set INDEX_DATABASE_URL")
+		return
+	}
+	ctx := c.Request.Context()
+	clusters, err := offchain.ClusterIncidents(ctx, req, tenantFromContext(ctx))
+	if err != nil {
+		respondServiceError(c, "Failed to cluster incidents", err)
+		return
+	}
+	respondData(c, http.StatusOK, clusters)
+}
+
+func getResponderBoard(c *gin.Context) {
+	units, err := rosterUnits(c.Request.Context())
+	if err != nil {
+		respondServiceError(c, "Failed to read the unit roster", err)
+		return
+	}
+	districts := map[string]*ResponderDistrict{}
+	for _, unit := range units {
+		name := unitDistrict(unit.PoliceUnit)
+		d := districts[name]
+		if d == nil {
+			d = &ResponderDistrict{District: name, ByStatus: map[string]int{}}
+			districts[name] = d
+		}
+		d.ByStatus[unit.Status]++
+		d.Units = append(d.Units, unit)
+	}
+	board := ResponderBoard{GeneratedAt: time.Now().UTC().Format(time.RFC3339), Districts: []ResponderDistrict{}}
+	for _, name := range sortedKeys(districts) {
+		board.Districts = append(board.Districts, *districts[name])
+	}
+	respondData(c, http.StatusOK, board)
+}
+
+// getDistrictDetail drills into one district. Clusters are left out without the off-chain index.
+func getDistrictDetail(c *gin.Context) {
+	name, ok := validPathID(c, "district")
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	t := tenantFromContext(ctx)
+	count, incidents := liveBoard.district(t, name)
+	detail := DistrictDetail{DistrictCount: count, Incidents: incidents, Units: []DispatchUnit{}}
+
+	units, err := rosterUnits(ctx)
+	if err != nil {
+		respondServiceError(c, "Failed to read the unit roster", err)
+		return
+	}
+	for _, unit := range units {
+		if unitDistrict(unit.PoliceUnit) == name {
+			detail.Units = append(detail.Units, unit)
+		}
+	}
+	if offchain != nil {
+		clusters, err := offchain.ClusterIncidents(ctx, ClusterRequest{District: name}, t)
+		if err != nil {
+			respondServiceError(c, "Failed to cluster incidents", err)
+			return
+		}
+		detail.Clusters = clusters.Clusters
+	}
+	respondData(c, http.StatusOK, detail)
+}
@@ -0,0 +1,70 @@
+package gateway
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestIncidentClustering(t *testing.T) {
+	start := time.Date(2025, 1, 1, 10, 0, 0, 0, time.UTC)
+	point := func(id string, lat, lng float64, minutes int) clusterPoint {
+		at := start.Add(time.Duration(minutes) * time.Minute)
+		return clusterPoint{incident: IncidentDocument{IncidentID: id, Status: "open", Severity: "high", CreatedAt: at.Format(time.RFC3339)}, lat: lat, lng: lng, at: at}
+	}
+
+	// INC-1 and INC-3 are 1.4km apart but chained through INC-2; INC-4 is nearby but hours later
+	// and INC-5 is in another city
+	groups := clusterPoints([]clusterPoint{
+		point("INC-1", 26.1445, 91.7362, 0),
+		point("INC-2", 26.1500, 91.7400, 20),
+		point("INC-3", 26.1560, 91.7430, 40),
+		point("INC-4", 26.1445, 91.7362, 300),
+		point("INC-5", 25.5788, 91.8933, 45),
+	}, 1, 2*time.Hour)
+	if len(groups) != 3 || len(groups[0]) != 3 {
+		t.Fatalf("expected INC-1 to INC-3 to cluster, got %+v", groups)
+	}
+	c := newIncidentCluster(groups[0])
+	if c.ClusterID != "INC-1" || c.Count != 3 || c.Open != 3 || c.LastAt != "2025-01-01T10:40:00Z" || c.BySeverity["high"] != 3 {
+		t.Errorf("unexpected cluster %+v", c)
+	}
+}
+
+func TestLiveBoard(t *testing.T) {
+	previous := policeUnits
+	policeUnits = []PoliceUnit{
+		{ID: "guwahati", District: "kamrup-metro", Latitude: 26.1445, Longitude: 91.7362},
+		{ID: "shillong", District: "east-khasi-hills", Latitude: 25.5788, Longitude: 91.8933},
+	}
+	defer func() { policeUnits = previous }()
+
+	b := newIncidentBoard()
+	guwahati := encodeGeohash(26.15, 91.74, ledgerGeohashPrecision)
+	b.apply(IncidentDocument{IncidentID: "INC-1", Status: "open", Severity: "high", Geohash: guwahati, OwnerOrg: "Org1MSP"})
+	b.apply(IncidentDocument{IncidentID: "INC-2", Status: "open", Severity: "low", Geohash: encodeGeohash(25.57, 91.88, ledgerGeohashPrecision), OwnerOrg: "Org2MSP"})
+	b.apply(IncidentDocument{IncidentID: "INC-3", Status: "open", Severity: "low"})
+
+	counts := b.counts(tenant{all: true})
+	if counts.Open != 3 || counts.Source != "events" || len(counts.Districts) != 3 || counts.Districts[1].District != "kamrup-metro" {
+		t.Fatalf("unexpected counts %+v", counts)
+	}
+	if org := b.counts(tenant{org: "Org2MSP"}); org.Open != 1 || org.Districts[0].District != "east-khasi-hills" {
+		t.Errorf("expected the tenant to see only its incident, got %+v", org)
+	}
+
+	// A waiting request wakes when an incident is resolved
+	done := make(chan LiveCounts)
+	go func() {
+		counts, _ := b.wait(context.Background(), tenant{all: true}, counts.Version, time.Second)
+		done <- counts
+	}()
+	time.Sleep(10 * time.Millisecond)
+	b.apply(IncidentDocument{IncidentID: "INC-1", Status: "resolved", Geohash: guwahati})
+	if woke := <-done; woke.Open != 2 || woke.Version <= counts.Version {
+		t.Fatalf("expected the resolution to wake the wait, got %+v", woke)
+	}
+	if count, incidents := b.district(tenant{all: true}, "kamrup-metro"); count.Open != 0 || len(incidents) != 0 {
+		t.Errorf("expected no open incidents left in Kamrup Metro, got %+v", incidents)
+	}
+}
@@ -0,0 +1,453 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	decisionQueueKey        = "sih:decisions:pending"
+	decisionFeaturesPrefix  = "decisions/"
+	maxModelDecisionsPerTx  = 500
+	maxQueuedModelDecisions = 100000
+
+	anomalyModel = "anomaly-detector"
+	safetyModel  = "safety-engine"
+
+	decisionIncident = "incident"
+	decisionPush     = "push_alert"
+	decisionNoAction = "no_action"
+	alertSafetyDrop  = "safety_drop"
+)
+
+// ModelDecision records what an automated model decided on an alert: an anomaly the detector raised
+// or a drop in a tourist's safety level. FeatureHash is a SHA-256 over the canonical JSON of the
+// inputs the model saw, which are kept in evidence storage rather than on the ledger. An incident
+// the decision raised has the alert's ID.
+type ModelDecision struct {
+	DecisionID   string  `json:"decision_id"`
+	AlertID      string  `json:"alert_id"`
+	AlertType    string  `json:"alert_type"`
+	DigitalID    string  `json:"digital_id"`
+	Model        string  `json:"model"`
+	ModelVersion string  `json:"model_version"`
+	FeatureHash  string  `json:"feature_hash"`
+	Score        float64 `json:"score"`
+	Decision     string  `json:"decision"`
+	DecidedAt    string  `json:"decided_at"`
+	AnchoredAt   string  `json:"anchored_at,omitempty"`
+	TxID         string  `json:"tx_id,omitempty"`
+}
+
+// DecisionProvenance is an anchored decision with the features it was made on, when they are still
+// stored. FeaturesVerified reports whether they hash to the anchored feature hash.
+type DecisionProvenance struct {
+	ModelDecision
+	Features         json.RawMessage `json:"features,omitempty"`
+	FeaturesVerified bool            `json:"features_verified"`
+}
+
+// VerifyFeaturesRequest carries the inputs a decision is claimed to have been made on
+type VerifyFeaturesRequest struct {
+	Features json.RawMessage `json:"features" binding:"required"`
+}
+
+func (r VerifyFeaturesRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	if !json.Valid(r.Features) || !bytes.HasPrefix(bytes.TrimSpace(r.Features), []byte("{")) {
+		v.add("features", "must be a JSON object")
+	}
+	return v.errors
+}
+
+// FeatureVerification reports whether submitted features match an anchored decision
+type FeatureVerification struct {
+	DecisionID  string `json:"decision_id"`
+	FeatureHash string `json:"feature_hash"`
+	Match       bool   `json:"match"`
+}
+
+// decisionQueue holds decisions until they are anchored
+type decisionQueue interface {
+	push(ctx context.Context, decisions []ModelDecision) error
+	// pop removes and returns up to n of the oldest decisions
+	pop(ctx context.Context, n int) ([]ModelDecision, error)
+}
+
+// decisionRecorder anchors model decisions on the default target every
+// MODEL_DECISION_ANCHOR_INTERVAL, in batches, after storing each decision's features
+type decisionRecorder struct {
+	queue          decisionQueue
+	interval       time.Duration
+	anomalyVersion string
+	safetyVersion  string
+}
+
+var decisions *decisionRecorder
+
+// initModelDecisions runs after initEvidenceStore and initDocumentCache. MODEL_DECISION_ANCHOR_INTERVAL=0
+// disables recording decisions.
+func initModelDecisions() {
+	interval := getEnvDuration("MODEL_DECISION_ANCHOR_INTERVAL", 10*time.Second)
+	if interval <= 0 {
+		log.Println("⚖️ MODEL_DECISION_ANCHOR_INTERVAL is 0, model decisions are not anchored")
+		return
+	}
+	var queue decisionQueue = &memoryDecisionQueue{}
+	backend := "memory"
+	if documentCache != nil {
+		queue, backend = redisDecisionQueue{documentCache}, "Redis"
+	}
+	decisions = &decisionRecorder{
+		queue:          queue,
+		interval:       interval,
+		anomalyVersion: getEnv("ANOMALY_MODEL_VERSION", "rules-1"),
+		safetyVersion:  getEnv("SAFETY_MODEL_VERSION", "weighted-1"),
+	}
+	log.Printf("⚖️ Anchoring model decisions every %s, queued in %s", interval, backend)
+}
+
+func modelDecisionID(alertID string) string {
+	return "DEC:" + alertID
+}
+
+func decisionFeaturesKey(alertID string) string {
+	return decisionFeaturesPrefix + alertID + ".json"
+}
+
+// canonicalFeatures re-encodes features with object keys sorted, so the same inputs hash the same
+// however they were serialized
+func canonicalFeatures(features []byte) ([]byte, error) {
+	var value interface{}
+	if err := json.Unmarshal(features, &value); err != nil {
+		return nil, err
+	}
+	return json.Marshal(value)
+}
+
+func featureHash(canonical []byte) string {
+	sum := sha256.Sum256(canonical)
+	return hex.EncodeToString(sum[:])
+}
+
+// record stores the features a decision was made on and queues the decision for anchoring. A
+// decision whose features cannot be stored is still anchored, as the hash alone lets whoever holds
+// the inputs prove them later.
+func (r *decisionRecorder) record(ctx context.Context, decision ModelDecision, features interface{}) {
+	if r == nil {
+		return
+	}
+	encoded, err := json.Marshal(features)
+	if err == nil {
+		encoded, err = canonicalFeatures(encoded)
+	}
+	if err != nil {
+		log.Printf("⚖️ Failed to encode the features of decision %s: %v", decision.AlertID, err)
+		return
+	}
+	decision.DecisionID = modelDecisionID(decision.AlertID)
+	decision.FeatureHash = featureHash(encoded)
+	if err := evidenceStore.Put(ctx, decisionFeaturesKey(decision.AlertID), bytes.NewReader(encoded), int64(len(encoded)), "application/json"); err != nil {
+		log.Printf("⚖️ Failed to store the features of decision %s: %v", decision.AlertID, err)
+	}
+	if err := r.queue.push(ctx, []ModelDecision{decision}); err != nil {
+		log.Printf("⚖️ Dropped model decision %s: %v", decision.AlertID, err)
+	}
+}
+
+// anomaly records how the detector handled one of its events
+func (r *decisionRecorder) anomaly(ctx context.Context, d *anomalyDetector, e AnomalyEvent, decision string) {
+	if r == nil {
+		return
+	}
+	r.record(ctx, ModelDecision{
+		AlertID: e.AnomalyID, AlertType: e.Type, DigitalID: e.DigitalID, Model: anomalyModel, ModelVersion: r.anomalyVersion,
+		Score: e.Score, Decision: decision, DecidedAt: time.Now().UTC().Format(time.RFC3339),
+	}, anomalyFeatures{Event: e, Thresholds: d.thresholds()})
+}
+
+// safetyDrop records a fall in a tourist's safety level; the decision is the level they fell to
+func (r *decisionRecorder) safetyDrop(ctx context.Context, alertID string, previous, score SafetyScore) {
+	if r == nil {
+		return
+	}
+	r.record(ctx, ModelDecision{
+		AlertID: alertID, AlertType: alertSafetyDrop, DigitalID: score.DigitalID, Model: safetyModel, ModelVersion: r.safetyVersion,
+		Score: float64(score.Score), Decision: score.Level, DecidedAt: time.Now().UTC().Format(time.RFC3339),
+	}, safetyDropFeatures{PreviousScore: previous.Score, PreviousLevel: previous.Level, Score: score})
+}
+
+// anomalyFeatures are the inputs behind an anomaly decision: the event's measurements and the
+// thresholds it was scored and routed against
+type anomalyFeatures struct {
+	Event      AnomalyEvent      `json:"event"`
+	Thresholds anomalyThresholds `json:"thresholds"`
+}
+
+type anomalyThresholds struct {
+	CorridorKm       float64 `json:"corridor_km"`
+	DeviationAfter   string  `json:"deviation_after"`
+	Inactivity       string  `json:"inactivity"`
+	StillRadiusM     float64 `json:"still_radius_m"`
+	SignalLoss       string  `json:"signal_loss"`
+	MaxSpeedKmh      float64 `json:"max_speed_kmh"`
+	IncidentMinScore float64 `json:"incident_min_score"`
+	NotifyMinScore   float64 `json:"notify_min_score"`
+}
+
+func (d *anomalyDetector) thresholds() anomalyThresholds {
+	return anomalyThresholds{
+		CorridorKm: d.corridorKm, DeviationAfter: d.deviationAfter.String(), Inactivity: d.inactivity.String(),
+		StillRadiusM: d.stillRadius, SignalLoss: d.signalLoss.String(), MaxSpeedKmh: d.maxSpeedKmh,
+		IncidentMinScore: d.incidentMinScore, NotifyMinScore: d.notifyMinScore,
+	}
+}
+
+// safetyDropFeatures are the inputs behind a safety drop: the new score with the weighted risks
+// behind it, and the score it fell from
+type safetyDropFeatures struct {
+	PreviousScore int         `json:"previous_score"`
+	PreviousLevel string      `json:"previous_level"`
+	Score         SafetyScore `json:"score"`
+}
+
+// run anchors the queued decisions every interval until ctx is done
+func (r *decisionRecorder) run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.anchor(withTarget(ctx, defaultTarget)); err != nil {
+				log.Printf("⚖️ Failed to anchor model decisions: %v", err)
+			}
+		}
+	}
+}
+
+// anchor submits the queued decisions in batches. A batch whose transaction fails is queued again
+// for the next round; the chaincode skips decisions it already holds, so a batch that committed
+// despite the error is not recorded twice.
+func (r *decisionRecorder) anchor(ctx context.Context) error {
+	anchored := 0
+	defer func() {
+		if anchored > 0 {
+			log.Printf("⚖️ Anchored %d model decisions", anchored)
+		}
+	}()
+	for {
+		batch, err := r.queue.pop(ctx, maxModelDecisionsPerTx)
+		if err != nil || len(batch) == 0 {
+			return err
+		}
+		payload, err := json.Marshal(batch)
+		if err == nil {
+			_, err = submitTransaction(ctx, "AnchorModelDecisions", string(payload))
+		}
+		if err != nil {
+			if requeueErr := r.queue.push(context.Background(), batch); requeueErr != nil {
+				log.Printf("⚖️ Dropped %d model decisions: %v", len(batch), requeueErr)
+			}
+			return err
+		}
+		anchored += len(batch)
+	}
+}
+
+func (ledgerService) ReadModelDecision(ctx context.Context, alertID string) (*ModelDecision, error) {
+	if errs := validateDocumentID("alertId", alertID); len(errs) > 0 {
+		return nil, errs
+	}
+	result, err := evaluateTransaction(ctx, "ReadModelDecision", modelDecisionID(alertID))
+	if err != nil {
+		return nil, err
+	}
+	decision, err := decodeDocument[ModelDecision](result, "model decision")
+	if err != nil {
+		return nil, err
+	}
+	return &decision, nil
+}
+
+func (ledgerService) ListModelDecisions(ctx context.Context, digitalID string) ([]ModelDecision, error) {
+	if errs := validateDocumentID("digitalId", digitalID); len(errs) > 0 {
+		return nil, errs
+	}
+	result, err := evaluateTransaction(ctx, "GetModelDecisions", digitalID)
+	if err != nil {
+		return nil, err
+	}
+	list, err := decodeDocument[[]ModelDecision](result, "model decision")
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].DecidedAt > list[j].DecidedAt })
+	return list, nil
+}
+
+// decisionProvenance reads an anchored decision with the features stored for it
+func decisionProvenance(ctx context.Context, alertID string) (*DecisionProvenance, error) {
+	decision, err := ledger.ReadModelDecision(ctx, alertID)
+	if err != nil {
+		return nil, err
+	}
+	provenance := &DecisionProvenance{ModelDecision: *decision}
+	body, err := evidenceStore.Get(ctx, decisionFeaturesKey(alertID))
+	if errors.Is(err, errObjectNotFound) {
+		return provenance, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	var features bytes.Buffer
+	if _, err := features.ReadFrom(body); err != nil {
+		return nil, err
+	}
+	provenance.Features = features.Bytes()
+	provenance.FeaturesVerified = featureHash(features.Bytes()) == decision.FeatureHash
+	return provenance, nil
+}
+
+func getDecisionProvenance(c *gin.Context) {
+	id, ok := validPathID(c, "alertId")
+	if !ok {
+		return
+	}
+
+	provenance, err := decisionProvenance(c.Request.Context(), id)
+	if err != nil {
+		respondServiceError(c, "Failed to read the model decision", err)
+		return
+	}
+
+	respondData(c, http.StatusOK, provenance)
+}
+
+func listModelDecisions(c *gin.Context) {
+	id, ok := validPathID(c, "digitalId")
+	if !ok {
+		return
+	}
+
+	list, err := ledger.ListModelDecisions(c.Request.Context(), id)
+	if err != nil {
+		respondServiceError(c, "Failed to list model decisions", err)
+		return
+	}
+
+	respondData(c, http.StatusOK, list)
+}
+
+// verifyDecisionFeatures checks inputs someone claims a decision was made on against its anchored hash
+func verifyDecisionFeatures(c *gin.Context) {
+	id, ok := validPathID(c, "alertId")
+	if !ok {
+		return
+	}
+	var req VerifyFeaturesRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+
+	decision, err := ledger.ReadModelDecision(c.Request.Context(), id)
+	if err != nil {
+		respondServiceError(c, "Failed to read the model decision", err)
+		return
+	}
+	canonical, err := canonicalFeatures(req.Features)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("Invalid features: %v", err))
+		return
+	}
+	hash := featureHash(canonical)
+
+	respondData(c, http.StatusOK, FeatureVerification{DecisionID: decision.DecisionID, FeatureHash: hash, Match: hash == decision.FeatureHash})
+}
+
+// redisDecisionQueue keeps the queue in a Redis list, so decisions survive a restart and any
+// instance can anchor them
+type redisDecisionQueue struct {
+	redis *redisClient
+}
+
+func (q redisDecisionQueue) push(ctx context.Context, list []ModelDecision) error {
+	args := []string{"RPUSH", decisionQueueKey}
+	for _, decision := range list {
+		data, err := json.Marshal(decision)
+		if err != nil {
+			return err
+		}
+		args = append(args, string(data))
+	}
+	_, err := q.redis.Do(ctx, args...)
+	return err
+}
+
+func (q redisDecisionQueue) pop(ctx context.Context, n int) ([]ModelDecision, error) {
+	reply, err := q.redis.Do(ctx, "LPOP", decisionQueueKey, strconv.Itoa(n))
+	if errors.Is(err, errRedisNil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	items, _ := reply.([]interface{})
+	list := make([]ModelDecision, 0, len(items))
+	for _, item := range items {
+		raw, _ := item.([]byte)
+		var decision ModelDecision
+		if err := json.Unmarshal(raw, &decision); err != nil {
+			log.Printf("⚖️ Dropped an unreadable queued model decision: %v", err)
+			continue
+		}
+		list = append(list, decision)
+	}
+	return list, nil
+}
+
+// memoryDecisionQueue serves a single gateway instance; queued decisions are lost on restart
+type memoryDecisionQueue struct {
+	mu      sync.Mutex
+	pending []ModelDecision
+}
+
+func (q *memoryDecisionQueue) push(_ context.Context, list []ModelDecision) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.pending)+len(list) > maxQueuedModelDecisions {
+		return fmt.Errorf("the queue already holds %d decisions", len(q.pending))
+	}
+	q.pending = append(q.pending, list...)
+	return nil
+}
+
+func (q *memoryDecisionQueue) pop(_ context.Context, n int) ([]ModelDecision, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n = min(n, len(q.pending))
+	list := append([]ModelDecision(nil), q.pending[:n]...)
+	q.pending = q.pending[n:]
+	return list, nil
+}
@@ -0,0 +1,86 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestModelDecisions(t *testing.T) {
+	store, err := newLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	previousStore, previousDecisions := evidenceStore, decisions
+	evidenceStore = store
+	queue := &memoryDecisionQueue{}
+	decisions = &decisionRecorder{queue: queue, anomalyVersion: "rules-1", safetyVersion: "weighted-1"}
+	defer func() { evidenceStore, decisions, geofence = previousStore, previousDecisions, nil }()
+	ctx := context.Background()
+
+	// An anomaly below both thresholds is decided on too, with the thresholds among its features
+	d := &anomalyDetector{incidentMinScore: 0.8, notifyMinScore: 0.5, maxSpeedKmh: 250, signalLoss: 15 * time.Minute}
+	event := AnomalyEvent{AnomalyID: "ANOM-20250920T080000Z-0a1b2c3d4e", Type: anomalySpeedJump, DigitalID: "did:sih:tourist_001", Score: 0.3, At: "2025-09-20T08:00:00Z"}
+	d.raise(ctx, []AnomalyEvent{event})
+	queued, _ := queue.pop(ctx, maxModelDecisionsPerTx)
+	if len(queued) != 1 || queued[0].DecisionID != "DEC:"+event.AnomalyID || queued[0].Decision != decisionNoAction || queued[0].ModelVersion != "rules-1" {
+		t.Fatalf("expected the anomaly's decision queued, got %+v", queued)
+	}
+	body, err := store.Get(ctx, decisionFeaturesKey(event.AnomalyID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	features, _ := io.ReadAll(body)
+	body.Close()
+	if featureHash(features) != queued[0].FeatureHash || !strings.Contains(string(features), `"max_speed_kmh":250`) {
+		t.Errorf("expected the stored features to match the hash, got %s", features)
+	}
+
+	// The hash does not depend on how the features are serialized
+	var reordered map[string]json.RawMessage
+	if err := json.Unmarshal(features, &reordered); err != nil {
+		t.Fatal(err)
+	}
+	spaced := `{ "thresholds": ` + string(reordered["thresholds"]) + `, "event": ` + string(reordered["event"]) + ` }`
+	if canonical, err := canonicalFeatures([]byte(spaced)); err != nil || featureHash(canonical) != queued[0].FeatureHash {
+		t.Errorf("expected the reordered features to hash the same, got %v", err)
+	}
+
+	// A fall in safety level is an alert, decided once per drop
+	geofence = newGeofenceIndex()
+	cliff, err := newIndexedZone(ZoneDocument{ZoneID: "cliff", Name: "Cliff edge", Geometry: `{"type":"Circle","coordinates":[91.885,25.575],"radius":500}`, RiskLevel: "restricted"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	geofence.put(cliff)
+	weights, _ := parseSafetyWeights(defaultSafetyWeights)
+	e := &safetyEngine{store: newMemorySafetyStore(time.Hour, 24*time.Hour), weights: weights, location: time.UTC, historyWindow: 24 * time.Hour}
+	id := "did:sih:tourist_001"
+	if _, err := e.update(ctx, id, 25.60, 91.95, nil); err != nil {
+		t.Fatal(err)
+	}
+	if queued, _ := queue.pop(ctx, maxModelDecisionsPerTx); len(queued) != 0 {
+		t.Fatalf("expected no decision for a first score, got %+v", queued)
+	}
+	score, err := e.update(ctx, id, 25.575, 91.885, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queued, _ = queue.pop(ctx, maxModelDecisionsPerTx)
+	if len(queued) != 1 || queued[0].AlertType != alertSafetyDrop || queued[0].Decision != score.Level || queued[0].Model != safetyModel || !strings.HasPrefix(queued[0].AlertID, "SDROP-") {
+		t.Errorf("expected the drop to %s decided, got %+v", score.Level, queued)
+	}
+	if _, err := e.update(ctx, id, 25.575, 91.885, nil); err != nil {
+		t.Fatal(err)
+	}
+	if queued, _ := queue.pop(ctx, maxModelDecisionsPerTx); len(queued) != 0 {
+		t.Errorf("expected no decision while the level holds, got %+v", queued)
+	}
+
+	if errs := (VerifyFeaturesRequest{Features: json.RawMessage(`[1]`)}).Validate(); len(errs) == 0 {
+		t.Error("expected features other than an object rejected")
+	}
+}
@@ -0,0 +1,239 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+// Incident deduplication. Several witnesses reporting one event open several incidents within
+// minutes of each other. The open incidents of a category and organization that are close in place
+// and time form a duplicate cluster, which the control room can merge into its first report; with
+// auto-merge each new report joining a cluster is merged as it arrives.
+
+const (
+	errCodeDedupDisabled = "DEDUP_DISABLED"
+
+	// maxMergedIncidents matches the chaincode's limit on duplicates merged in one transaction
+	maxMergedIncidents = 20
+)
+
+// DuplicateCluster is a group of open reports that look like one event. PrimaryID is the earliest
+// report, which the others would be merged into.
+type DuplicateCluster struct {
+	IncidentCluster
+	Category     string   `json:"category"`
+	PrimaryID    string   `json:"primary_id"`
+	DuplicateIDs []string `json:"duplicate_ids"`
+}
+
+// DuplicateClusters lists the duplicate clusters on the live board, largest first
+type DuplicateClusters struct {
+	GeneratedAt string             `json:"generated_at"`
+	RadiusKm    float64            `json:"radius_km"`
+	Window      string             `json:"window"`
+	AutoMerge   bool               `json:"auto_merge"`
+	Clusters    []DuplicateCluster `json:"clusters"`
+}
+
+type DuplicatesRequest struct {
+	District string `form:"district"`
+	Category string `form:"category"`
+}
+
+func (r DuplicatesRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	if r.Category != "" {
+		v.oneOf("category", r.Category, incidentCategories)
+	}
+	return v.errors
+}
+
+// incidentDedup finds duplicate reports among the open incidents on the live board
+type incidentDedup struct {
+	radiusKm  float64
+	window    time.Duration
+	autoMerge bool
+	actor     string
+}
+
+var dedup *incidentDedup
+
+func initDedup() {
+	if !getEnvBool("DEDUP_ENABLED", true) {
+		log.Println("🧬 Incident deduplication disabled")
+		return
+	}
+	d := &incidentDedup{
+		radiusKm:  getEnvFloat("DEDUP_RADIUS_KM", 0.5),
+		window:    getEnvDuration("DEDUP_WINDOW", 20*time.Minute),
+		autoMerge: getEnvBool("DEDUP_AUTO_MERGE", false),
+		actor:     getEnv("DEDUP_ACTOR", "incident-dedup"),
+	}
+	if d.radiusKm <= 0 || d.radiusKm > maxClusterRadiusKm {
+		panic(fmt.Errorf("DEDUP_RADIUS_KM must be between 0 and %g", maxClusterRadiusKm))
+	}
+	dedup = d
+	mode := "suggesting merges"
+	if d.autoMerge {
+		mode = "merging new reports automatically"
+	}
+	log.Printf("🧬 Incident deduplication within %gkm and %s, %s", d.radiusKm, d.window, mode)
+}
+
+// dedupKey groups the incidents that can be merged together: the chaincode only merges incidents
+// of one organization, and reports of different categories are different events
+func dedupKey(incident IncidentDocument) string {
+	return incident.OwnerOrg + "|" + incident.Category
+}
+
+// dedupPoint places an open incident with a category and location, or reports that it has none
+func dedupPoint(incident IncidentDocument) (clusterPoint, bool) {
+	cell, ok := geohashBounds(incident.Geohash)
+	at, err := time.Parse(time.RFC3339, incident.CreatedAt)
+	if !ok || err != nil || incident.Category == "" || !incidentOpen(incident.Status) {
+		return clusterPoint{}, false
+	}
+	return clusterPoint{incident: incident, lat: (cell.minLat + cell.maxLat) / 2, lng: (cell.minLng + cell.maxLng) / 2, at: at}, true
+}
+
+// comparePoints orders points by time, then incident ID
+func comparePoints(a, b clusterPoint) int {
+	return cmp.Or(a.at.Compare(b.at), strings.Compare(a.incident.IncidentID, b.incident.IncidentID))
+}
+
+// clusters groups the incidents into duplicate clusters, largest first
+func (d *incidentDedup) clusters(incidents []IncidentDocument) []DuplicateCluster {
+	groups := map[string][]clusterPoint{}
+	for _, incident := range incidents {
+		if p, ok := dedupPoint(incident); ok {
+			groups[dedupKey(incident)] = append(groups[dedupKey(incident)], p)
+		}
+	}
+	clusters := []DuplicateCluster{}
+	for _, key := range sortedKeys(groups) {
+		points := groups[key]
+		slices.SortFunc(points, comparePoints)
+		for _, group := range clusterPoints(points, d.radiusKm, d.window) {
+			if len(group) < 2 {
+				continue
+			}
+			c := DuplicateCluster{IncidentCluster: newIncidentCluster(group), Category: group[0].incident.Category}
+			c.PrimaryID, c.DuplicateIDs = c.IncidentIDs[0], c.IncidentIDs[1:]
+			clusters = append(clusters, c)
+		}
+	}
+	slices.SortFunc(clusters, func(a, b DuplicateCluster) int {
+		return cmp.Or(b.Count-a.Count, strings.Compare(b.LastAt, a.LastAt))
+	})
+	return clusters
+}
+
+// primaryFor returns the earliest open incident that the new report duplicates: one of the same
+// category and organization, reported no later and within the radius and window of it
+func (d *incidentDedup) primaryFor(report IncidentDocument, open []IncidentDocument) (IncidentDocument, bool) {
+	target, ok := dedupPoint(report)
+	if !ok {
+		return IncidentDocument{}, false
+	}
+	var candidates []clusterPoint
+	for _, incident := range open {
+		p, ok := dedupPoint(incident)
+		if !ok || incident.IncidentID == report.IncidentID || dedupKey(incident) != dedupKey(report) {
+			continue
+		}
+		// Reports in the same second are ordered by ID, so two of them never merge into each other
+		if comparePoints(p, target) > 0 || target.at.Sub(p.at) > d.window {
+			continue
+		}
+		if haversineKm(p.lat, p.lng, target.lat, target.lng) <= d.radiusKm {
+			candidates = append(candidates, p)
+		}
+	}
+	if len(candidates) == 0 {
+		return IncidentDocument{}, false
+	}
+	slices.SortFunc(candidates, comparePoints)
+	return candidates[0].incident, true
+}
+
+// located returns the tenant's open incidents on the board
+func (b *incidentBoard) located(t tenant) []IncidentDocument {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	incidents := make([]IncidentDocument, 0, len(b.open))
+	for _, incident := range b.open {
+		if incident.Geohash != "" && t.owns(incident.OwnerOrg) {
+			incidents = append(incidents, incident.IncidentDocument)
+		}
+	}
+	return incidents
+}
+
+// dedupFromEvent merges a new report into the incident it duplicates when auto-merge is on. It runs
+// after the live board has taken the report, and only one gateway instance acts on each report.
+func dedupFromEvent(ctx context.Context, event *client.ChaincodeEvent) {
+	if dedup == nil || !dedup.autoMerge || event.EventName != "CreateIncident" || !isDefaultTarget(ctx) {
+		return
+	}
+	report, err := decodeDocument[IncidentDocument](event.Payload, "incident event")
+	if err != nil {
+		return
+	}
+	primary, ok := dedup.primaryFor(report, liveBoard.located(tenant{all: true}))
+	if !ok {
+		return
+	}
+	if first, err := claimEvent(ctx, "dedup:"+report.IncidentID, 24*time.Hour); err != nil || !first {
+		return
+	}
+	go dedup.merge(context.WithoutCancel(ctx), primary.IncidentID, report.IncidentID)
+}
+
+func (d *incidentDedup) merge(ctx context.Context, primaryID, duplicateID string) {
+	_, err := ledger.MergeIncidents(ctx, primaryID, MergeIncidentsRequest{DuplicateIDs: []string{duplicateID}, Actor: d.actor})
+	if err != nil {
+		log.Printf("🧬 Failed to merge incident %s into %s: %v", duplicateID, primaryID, err)
+		return
+	}
+	log.Printf("🧬 Merged incident %s into %s", duplicateID, primaryID)
+}
+
+// getDuplicateClusters serves the duplicate clusters among the open incidents the tenant can see
+func getDuplicateClusters(c *gin.Context) {
+	var req DuplicatesRequest
+	if !bindQuery(c, &req) {
+		return
+	}
+	if dedup == nil {
+		respondError(c, http.StatusServiceUnavailable, errCodeDedupDisabled, "Incident deduplication is disabled; set DEDUP_ENABLED")
+		return
+	}
+	result := DuplicateClusters{
+		GeneratedAt: time.Now().UTC().Format(time.RFC3339),
+		RadiusKm:    dedup.radiusKm,
+		Window:      dedup.window.String(),
+		AutoMerge:   dedup.autoMerge,
+		Clusters:    []DuplicateCluster{},
+	}
+	for _, cluster := range dedup.clusters(liveBoard.located(tenantFromContext(c.Request.Context()))) {
+		if (req.District == "" || cluster.District == req.District) && (req.Category == "" || cluster.Category == req.Category) {
+			result.Clusters = append(result.Clusters, cluster)
+		}
+	}
+	respondData(c, http.StatusOK, result)
+}
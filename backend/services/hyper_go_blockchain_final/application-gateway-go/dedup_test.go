@@ -0,0 +1,80 @@
+package gateway
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestDuplicateClusters(t *testing.T) {
+	start := time.Date(2025, 1, 1, 10, 0, 0, 0, time.UTC)
+	report := func(id, category, org string, lat, lng float64, minutes int) IncidentDocument {
+		return IncidentDocument{
+			IncidentID: id, Status: "open", Severity: "high", Category: category, OwnerOrg: org,
+			Geohash:   encodeGeohash(lat, lng, ledgerGeohashPrecision),
+			CreatedAt: start.Add(time.Duration(minutes) * time.Minute).Format(time.RFC3339),
+		}
+	}
+	incidents := []IncidentDocument{
+		report("INC-3", "theft", "Org1MSP", 26.1447, 91.7365, 9),
+		report("INC-1", "theft", "Org1MSP", 26.1445, 91.7362, 0),
+		report("INC-2", "theft", "Org1MSP", 26.1450, 91.7360, 4),
+		// Another category, another organization, too late and too far are separate events
+		report("INC-4", "medical", "Org1MSP", 26.1445, 91.7362, 2),
+		report("INC-5", "theft", "Org2MSP", 26.1445, 91.7362, 3),
+		report("INC-6", "theft", "Org1MSP", 26.1445, 91.7362, 120),
+		report("INC-7", "theft", "Org1MSP", 26.1900, 91.7362, 5),
+		{IncidentID: "INC-8", Status: "open", Category: "theft", OwnerOrg: "Org1MSP", CreatedAt: start.Format(time.RFC3339)},
+	}
+
+	d := &incidentDedup{radiusKm: 0.5, window: 20 * time.Minute}
+	clusters := d.clusters(incidents)
+	if len(clusters) != 1 {
+		t.Fatalf("expected one duplicate cluster, got %+v", clusters)
+	}
+	if c := clusters[0]; c.PrimaryID != "INC-1" || !slices.Equal(c.DuplicateIDs, []string{"INC-2", "INC-3"}) || c.Category != "theft" || c.Count != 3 {
+		t.Errorf("expected INC-2 and INC-3 to duplicate INC-1, got %+v", c)
+	}
+
+	// A new report merges into the earliest open report it duplicates, never a later one
+	if primary, ok := d.primaryFor(incidents[0], incidents); !ok || primary.IncidentID != "INC-1" {
+		t.Errorf("expected INC-3 to duplicate INC-1, got %+v", primary)
+	}
+	if _, ok := d.primaryFor(incidents[1], incidents); ok {
+		t.Error("expected the first report to duplicate nothing")
+	}
+	if _, ok := d.primaryFor(incidents[5], incidents); ok {
+		t.Error("expected a report outside the window to duplicate nothing")
+	}
+
+	// Reports in the same second only merge one way
+	twin := report("INC-0", "theft", "Org1MSP", 26.1445, 91.7362, 0)
+	if primary, _ := d.primaryFor(incidents[1], append(incidents, twin)); primary.IncidentID != "INC-0" {
+		t.Errorf("expected INC-1 to duplicate INC-0, got %+v", primary)
+	}
+	if primary, ok := d.primaryFor(twin, append(incidents, twin)); ok {
+		t.Errorf("expected INC-0 to duplicate nothing, got %+v", primary)
+	}
+}
+
+func TestMergeIncidentsValidation(t *testing.T) {
+	cases := []struct {
+		req   MergeIncidentsRequest
+		field string
+	}{
+		{MergeIncidentsRequest{Actor: "officer_7"}, "duplicateIDs"},
+		{MergeIncidentsRequest{DuplicateIDs: []string{"INC-2", "INC-2"}, Actor: "officer_7"}, "duplicateIDs[1]"},
+		{MergeIncidentsRequest{DuplicateIDs: []string{"INC 2"}, Actor: "officer_7"}, "duplicateIDs[0]"},
+	}
+	for _, tc := range cases {
+		if errs := tc.req.Validate(); len(errs) != 1 || errs[0].Field != tc.field {
+			t.Errorf("expected %s rejected, got %v", tc.field, errs)
+		}
+	}
+	// Merging an incident into itself is refused before the ledger is asked
+	_, err := ledger.MergeIncidents(context.Background(), "INC-1", MergeIncidentsRequest{DuplicateIDs: []string{"INC-1"}, Actor: "officer_7"})
+	if errs, ok := err.(ValidationErrors); !ok || errs[0].Field != "duplicateIDs" {
+		t.Errorf("expected the primary refused as a duplicate, got %v", err)
+	}
+}
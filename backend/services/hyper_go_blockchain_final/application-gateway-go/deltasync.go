@@ -0,0 +1,345 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultDeltaSyncLimit = 500
+	maxDeltaSyncLimit     = 2000
+	// allDistricts is the district role that reaches every district, for control rooms
+	allDistricts = "*"
+
+	errCodeSyncCursorExpired = "SYNC_CURSOR_EXPIRED"
+)
+
+// errSyncCursorAhead is a cursor past every change in the index, which happens when the index was
+// rebuilt after the cursor was issued
+var errSyncCursorAhead = errors.New("sync cursor is ahead of the index")
+
+// DeltaSyncRequest asks for the changes after a cursor. Without a cursor it returns the open
+// incidents and active assignments, to seed a new device.
+type DeltaSyncRequest struct {
+	Since    string `form:"since"`
+	District string `form:"district"`
+	Limit    int    `form:"limit"`
+
+	since int64
+}
+
+func (r *DeltaSyncRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	if r.Since != "" {
+		since, err := strconv.ParseInt(r.Since, 10, 64)
+		if err != nil || since < 0 {
+			v.add("since", "must be a cursor returned by an earlier sync")
+		}
+		r.since = since
+	}
+	if r.District != "" {
+		v.identifier("district", r.District)
+	}
+	if r.Limit != 0 && (r.Limit < 1 || r.Limit > maxDeltaSyncLimit) {
+		v.add("limit", "must be between 1 and %d", maxDeltaSyncLimit)
+	}
+	return v.errors
+}
+
+// DeltaIncident is an incident's current state, with only the fields a patrol app shows
+type DeltaIncident struct {
+	ID             string `json:"id"`
+	Status         string `json:"status"`
+	Severity       string `json:"severity,omitempty"`
+	Category       string `json:"category,omitempty"`
+	Geohash        string `json:"geohash,omitempty"`
+	CreatedAt      string `json:"created_at"`
+	AcknowledgedAt string `json:"acknowledged_at,omitempty"`
+	ResolvedAt     string `json:"resolved_at,omitempty"`
+
+	seq     int64
+	deleted bool
+}
+
+// DeltaAssignment is a dispatch of a unit to an incident or SOS alert
+type DeltaAssignment struct {
+	ID             string `json:"id"`
+	SubjectType    string `json:"subject_type"`
+	SubjectID      string `json:"subject_id"`
+	UnitID         string `json:"unit_id"`
+	Attempt        int    `json:"attempt"`
+	Status         string `json:"status"`
+	AssignedAt     string `json:"assigned_at"`
+	AckDeadline    string `json:"ack_deadline,omitempty"`
+	AcknowledgedAt string `json:"acknowledged_at,omitempty"`
+
+	seq int64
+	// geohash is where the subject was reported
+	geohash string
+}
+
+// DeltaEvidence is an evidence record; the file itself is fetched separately when needed
+type DeltaEvidence struct {
+	ID         string `json:"id"`
+	IncidentID string `json:"incident_id"`
+	Hash       string `json:"hash"`
+	MediaType  string `json:"media_type"`
+	CreatedAt  string `json:"created_at"`
+
+	seq     int64
+	geohash string
+	deleted bool
+}
+
+// DeltaTombstone names a record deleted since the cursor, for the app to drop
+type DeltaTombstone struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// DeltaSync is the changes in the caller's districts after the cursor. When More is set the app
+// syncs again at once from Cursor.
+type DeltaSync struct {
+	Cursor      string            `json:"cursor"`
+	More        bool              `json:"more,omitempty"`
+	Districts   []string          `json:"districts"`
+	Incidents   []DeltaIncident   `json:"incidents,omitempty"`
+	Assignments []DeltaAssignment `json:"assignments,omitempty"`
+	Evidence    []DeltaEvidence   `json:"evidence,omitempty"`
+	Deleted     []DeltaTombstone  `json:"deleted,omitempty"`
+}
+
+// callerDistricts returns the districts the caller's roles place it in: "district:<name>" roles,
+// and the district of the unit of each "unit:<id>" role
+func callerDistricts(ctx context.Context) []string {
+	subjects, _ := callerSubjects(ctx)
+	var districts []string
+	for _, role := range access.current().roles(subjects) {
+		district, ok := strings.CutPrefix(role, "district:")
+		if !ok {
+			if unitID, isUnit := strings.CutPrefix(role, "unit:"); isUnit {
+				if unit := policeUnitByID(unitID); unit != nil && unit.District != "" {
+					district, ok = unit.District, true
+				}
+			}
+		}
+		if ok && !slices.Contains(districts, district) {
+			districts = append(districts, district)
+		}
+	}
+	slices.Sort(districts)
+	return districts
+}
+
+// deltaSync returns the changes to incidents, their evidence and unit assignments after the
+// cursor. Without an access policy the caller names its district; with one, the caller syncs the
+// districts its roles place it in, or one of them.
+func deltaSync(c *gin.Context) {
+	var req DeltaSyncRequest
+	if !bindQuery(c, &req) {
+		return
+	}
+	if offchain == nil {
+		respondError(c, http.StatusServiceUnavailable, errCodeIndexDisabled, "Delta sync needs the off-chain index; set INDEX_DATABASE_URL")
+		return
+	}
+	ctx := c.Request.Context()
+
+	districts := []string{req.District}
+	switch {
+	case access == nil && req.District == "":
+		var v fieldValidator
+		v.add("district", "is required when no access policy is configured")
+		respondValidationErrors(c, v.errors)
+		return
+	case access != nil:
+		allowed := callerDistricts(ctx)
+		switch {
+		case len(allowed) == 0:
+			respondError(c, http.StatusForbidden, errCodeAccessDenied, "Delta sync needs a district:<name> or unit:<id> role")
+			return
+		case req.District == "":
+			districts = allowed
+		case !slices.Contains(allowed, req.District) && !slices.Contains(allowed, allDistricts):
+			respondError(c, http.StatusForbidden, errCodeAccessDenied, "The caller has no role in this district")
+			return
+		}
+	}
+
+	limit := req.Limit
+	if limit == 0 {
+		limit = defaultDeltaSyncLimit
+	}
+	delta, err := offchain.DeltaSync(ctx, req.since, req.Since == "", limit, tenantFromContext(ctx))
+	if errors.Is(err, errSyncCursorAhead) {
+		respondError(c, http.StatusGone, errCodeSyncCursorExpired, "The cursor is no longer valid; sync again without one")
+		return
+	} else if err != nil {
+		respondServiceError(c, "Failed to read changes", err)
+		return
+	}
+	delta.Districts = districts
+	respondData(c, http.StatusOK, delta.inDistricts(districts))
+}
+
+// DeltaSync reads up to limit changed rows of each table after since, up to the highest change
+// already visible. A seed reads only open incidents, their evidence and active assignments.
+// Rows are not yet narrowed to districts, so the cursor passes over other districts' changes.
+func (ix *offchainIndex) DeltaSync(ctx context.Context, since int64, seed bool, limit int, t tenant) (DeltaSync, error) {
+	// Every change numbered below the highest visible one is visible too, so nothing up to it is
+	// missed. The highest can fall when an archived incident leaves the index; only a cursor past
+	// every number ever issued is from an earlier index.
+	head, err := ix.db.Query(ctx, `SELECT GREATEST(
+		(SELECT COALESCE(MAX(sync_seq), 0) FROM incidents),
+		(SELECT COALESCE(MAX(sync_seq), 0) FROM evidence),
+		(SELECT COALESCE(MAX(sync_seq), 0) FROM assignments)), last_value FROM sync_seq`)
+	if err != nil {
+		return DeltaSync{}, err
+	}
+	cursor := head[0].Int(0)
+	if since > head[0].Int(1) {
+		return DeltaSync{}, errSyncCursorAhead
+	}
+	if cursor <= since {
+		return DeltaSync{Cursor: strconv.FormatInt(since, 10)}, nil
+	}
+
+	window := func(table string) *queryFilter {
+		var f queryFilter
+		f.add(table+".sync_seq > ?", since)
+		f.add(table+".sync_seq <= ?", cursor)
+		t.restrict(&f, table+".owner_org")
+		return &f
+	}
+
+	f := window("incidents")
+	if seed {
+		f.add("incidents.deleted_at IS NULL AND incidents.status NOT IN ('resolved', 'closed')")
+	}
+	rows, err := ix.db.Query(ctx, fmt.Sprintf(`SELECT sync_seq, incident_id, status, severity, category, geohash, %s, %s, %s,
+			deleted_at IS NOT NULL
+		FROM incidents WHERE %s ORDER BY sync_seq LIMIT %d`,
+		utcColumn("created_at"), utcColumn("acknowledged_at"), utcColumn("resolved_at"), f.where(), limit+1), f.args...)
+	if err != nil {
+		return DeltaSync{}, err
+	}
+	var delta DeltaSync
+	for _, row := range rows {
+		delta.Incidents = append(delta.Incidents, DeltaIncident{
+			seq: row.Int(0), ID: row.String(1), Status: row.String(2), Severity: row.String(3), Category: row.String(4),
+			Geohash: row.String(5), CreatedAt: row.String(6), AcknowledgedAt: row.String(7), ResolvedAt: row.String(8),
+			deleted: row.String(9) == "t",
+		})
+	}
+
+	f = window("evidence")
+	if seed {
+		f.add("evidence.deleted_at IS NULL AND incidents.deleted_at IS NULL AND incidents.status NOT IN ('resolved', 'closed')")
+	}
+	rows, err = ix.db.Query(ctx, fmt.Sprintf(`SELECT evidence.sync_seq, evidence_id, evidence.incident_id, evidence_hash, media_type, %s,
+			COALESCE(incidents.geohash, ''), evidence.deleted_at IS NOT NULL
+		FROM evidence LEFT JOIN incidents ON incidents.incident_id = evidence.incident_id
+		WHERE %s ORDER BY evidence.sync_seq LIMIT %d`,
+		utcColumn("evidence.created_at"), f.where(), limit+1), f.args...)
+	if err != nil {
+		return DeltaSync{}, err
+	}
+	for _, row := range rows {
+		delta.Evidence = append(delta.Evidence, DeltaEvidence{
+			seq: row.Int(0), ID: row.String(1), IncidentID: row.String(2), Hash: row.String(3), MediaType: row.String(4),
+			CreatedAt: row.String(5), geohash: row.String(6), deleted: row.String(7) == "t",
+		})
+	}
+
+	f = window("assignments")
+	if seed {
+		f.add("assignments.status IN ('assigned', 'acknowledged')")
+	}
+	rows, err = ix.db.Query(ctx, fmt.Sprintf(`SELECT assignments.sync_seq, assignment_id, subject_type, assignments.subject_id, unit_id,
+			attempt, assignments.status, %s, %s, %s, COALESCE(incidents.geohash, sos_alerts.geohash, '')
+		FROM assignments
+			LEFT JOIN incidents ON subject_type = 'incident' AND incidents.incident_id = assignments.subject_id
+			LEFT JOIN sos_alerts ON subject_type = 'sos' AND sos_alerts.alert_id = assignments.subject_id
+		WHERE %s ORDER BY assignments.sync_seq LIMIT %d`,
+		utcColumn("assigned_at"), utcColumn("ack_deadline"), utcColumn("assignments.acknowledged_at"), f.where(), limit+1), f.args...)
+	if err != nil {
+		return DeltaSync{}, err
+	}
+	for _, row := range rows {
+		delta.Assignments = append(delta.Assignments, DeltaAssignment{
+			seq: row.Int(0), ID: row.String(1), SubjectType: row.String(2), SubjectID: row.String(3), UnitID: row.String(4),
+			Attempt: int(row.Int(5)), Status: row.String(6), AssignedAt: row.String(7), AckDeadline: row.String(8),
+			AcknowledgedAt: row.String(9), geohash: row.String(10),
+		})
+	}
+
+	delta.truncate(cursor, limit)
+	return delta, nil
+}
+
+// truncate ends the delta at the earliest point a table ran past the limit, dropping later rows of
+// every table so the cursor never skips a change. Without truncation the cursor is the head.
+func (d *DeltaSync) truncate(head int64, limit int) {
+	cursor := head
+	if len(d.Incidents) > limit {
+		cursor, d.More = min(cursor, d.Incidents[limit-1].seq), true
+	}
+	if len(d.Evidence) > limit {
+		cursor, d.More = min(cursor, d.Evidence[limit-1].seq), true
+	}
+	if len(d.Assignments) > limit {
+		cursor, d.More = min(cursor, d.Assignments[limit-1].seq), true
+	}
+	d.Incidents = slices.DeleteFunc(d.Incidents, func(i DeltaIncident) bool { return i.seq > cursor })
+	d.Evidence = slices.DeleteFunc(d.Evidence, func(e DeltaEvidence) bool { return e.seq > cursor })
+	d.Assignments = slices.DeleteFunc(d.Assignments, func(a DeltaAssignment) bool { return a.seq > cursor })
+	d.Cursor = strconv.FormatInt(cursor, 10)
+}
+
+// inDistricts keeps the changes that concern the districts: incidents reported there, their
+// evidence, and assignments to a subject there or to a unit stationed there. Deleted records
+// become tombstones.
+func (d DeltaSync) inDistricts(districts []string) DeltaSync {
+	in := func(district string) bool {
+		return slices.Contains(districts, allDistricts) || slices.Contains(districts, district)
+	}
+	out := DeltaSync{Cursor: d.Cursor, More: d.More, Districts: d.Districts}
+	for _, incident := range d.Incidents {
+		switch {
+		case !in(districtOf(incident.Geohash)):
+		case incident.deleted:
+			out.Deleted = append(out.Deleted, DeltaTombstone{Type: "incident", ID: incident.ID})
+		default:
+			out.Incidents = append(out.Incidents, incident)
+		}
+	}
+	for _, evidence := range d.Evidence {
+		switch {
+		case !in(districtOf(evidence.geohash)):
+		case evidence.deleted:
+			out.Deleted = append(out.Deleted, DeltaTombstone{Type: "evidence", ID: evidence.ID})
+		default:
+			out.Evidence = append(out.Evidence, evidence)
+		}
+	}
+	for _, assignment := range d.Assignments {
+		unit := policeUnitByID(assignment.UnitID)
+		if in(districtOf(assignment.geohash)) || (unit != nil && unit.District != "" && in(unit.District)) {
+			out.Assignments = append(out.Assignments, assignment)
+		}
+	}
+	return out
+}
@@ -0,0 +1,89 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestDeltaSyncDistricts(t *testing.T) {
+	previous := policeUnits
+	policeUnits = []PoliceUnit{
+		{ID: "guwahati", District: "kamrup-metro", Latitude: 26.1445, Longitude: 91.7362},
+		{ID: "shillong", District: "east-khasi-hills", Latitude: 25.5788, Longitude: 91.8933},
+	}
+	defer func() { policeUnits = previous }()
+	shillong, guwahati := encodeGeohash(25.5788, 91.8933, 6), encodeGeohash(26.1445, 91.7362, 6)
+
+	delta := DeltaSync{
+		Incidents: []DeltaIncident{
+			{ID: "INC-SHG", Status: "open", Geohash: shillong, seq: 1},
+			{ID: "INC-GHY", Status: "open", Geohash: guwahati, seq: 2},
+			{ID: "INC-DEL", Geohash: shillong, seq: 3, deleted: true},
+		},
+		Evidence: []DeltaEvidence{
+			{ID: "EV-SHG", IncidentID: "INC-SHG", geohash: shillong, seq: 4},
+			{ID: "EV-GHY", IncidentID: "INC-GHY", geohash: guwahati, seq: 5},
+		},
+		Assignments: []DeltaAssignment{
+			{ID: "AS-1", SubjectID: "INC-GHY", UnitID: "guwahati", geohash: guwahati, seq: 6},
+			{ID: "AS-2", SubjectID: "INC-GHY", UnitID: "shillong", geohash: guwahati, seq: 7},
+		},
+	}
+	got := delta.inDistricts([]string{"east-khasi-hills"})
+	if len(got.Incidents) != 1 || got.Incidents[0].ID != "INC-SHG" || len(got.Evidence) != 1 || got.Evidence[0].ID != "EV-SHG" {
+		t.Errorf("expected only the district's incident and evidence, got %+v %+v", got.Incidents, got.Evidence)
+	}
+	if len(got.Deleted) != 1 || got.Deleted[0] != (DeltaTombstone{Type: "incident", ID: "INC-DEL"}) {
+		t.Errorf("expected the deleted incident as a tombstone, got %+v", got.Deleted)
+	}
+	if len(got.Assignments) != 1 || got.Assignments[0].ID != "AS-2" {
+		t.Errorf("expected the assignment of the district's unit, got %+v", got.Assignments)
+	}
+	if all := delta.inDistricts([]string{allDistricts}); len(all.Incidents) != 2 || len(all.Assignments) != 2 {
+		t.Errorf("expected every district reached, got %+v", all)
+	}
+
+	// A page cut short in one table ends every table at the same change
+	delta.truncate(9, 2)
+	if !delta.More || delta.Cursor != "2" || len(delta.Incidents) != 2 || len(delta.Evidence) != 0 || len(delta.Assignments) != 0 {
+		t.Errorf("expected the delta cut at the second incident, got %+v", delta)
+	}
+
+	policy, err := parseAccessPolicy("g, wallet:patrol-7, unit:shillong\ng, wallet:sp-ri-bhoi, district:ri-bhoi\ng, wallet:patrol-7, district:ri-bhoi\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	access = &accessControl{policy: policy}
+	defer func() { access = nil }()
+	if got := callerDistricts(withSigner(context.Background(), &walletIdentity{Label: "patrol-7", MSPID: "Org1MSP"})); !slices.Equal(got, []string{"east-khasi-hills", "ri-bhoi"}) {
+		t.Errorf("expected the unit's district and the named one, got %v", got)
+	}
+	if got := callerDistricts(withSigner(context.Background(), &walletIdentity{Label: "clerk", MSPID: "Org1MSP"})); len(got) != 0 {
+		t.Errorf("expected no districts without a role, got %v", got)
+	}
+}
+
+func TestDeltaSyncRequest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/sync", deltaSync)
+	get := func(query string) int {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/sync"+query, nil))
+		return w.Code
+	}
+
+	for _, query := range []string{"?since=-1", "?since=1.2", "?limit=5000", "?district=east%20khasi"} {
+		if code := get(query); code != http.StatusBadRequest {
+			t.Errorf("expected %s refused, got %d", query, code)
+		}
+	}
+	if code := get("?since=42&district=east-khasi-hills"); code != http.StatusServiceUnavailable {
+		t.Errorf("expected the sync unavailable without the index, got %d", code)
+	}
+}
@@ -0,0 +1,416 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+// DeviceDocument is a safety band in the registry. DigitalID and BindingID are empty while the
+// band is unbound; AttestationKey is empty for bands that cannot sign their telemetry.
+type DeviceDocument struct {
+	DeviceID       string `json:"device_id"`
+	Type           string `json:"type"`
+	AttestationKey string `json:"attestation_key,omitempty"`
+	DigitalID      string `json:"digital_id,omitempty"`
+	BindingID      string `json:"binding_id,omitempty"`
+	Bindings       int    `json:"bindings"`
+	RegisteredBy   string `json:"registered_by"`
+	RegisteredAt   string `json:"registered_at"`
+	UpdatedAt      string `json:"updated_at"`
+	OwnerOrg       string `json:"owner_org,omitempty"`
+	TxID           string `json:"tx_id"`
+}
+
+// DeviceBinding records a band being bound to or unbound from a tourist. Action is bind or unbind.
+type DeviceBinding struct {
+	BindingID string `json:"binding_id"`
+	DeviceID  string `json:"device_id"`
+	Sequence  int    `json:"sequence"`
+	Action    string `json:"action"`
+	DigitalID string `json:"digital_id"`
+	Actor     string `json:"actor"`
+	At        string `json:"at"`
+	OwnerOrg  string `json:"owner_org,omitempty"`
+	TxID      string `json:"tx_id"`
+}
+
+// RegisterWearableRequest adds a band by its serial. AttestationKey is the band's base64 Ed25519
+// public key, provisioned at manufacture.
+type RegisterWearableRequest struct {
+	DeviceID       string `json:"deviceID" binding:"required"`
+	Type           string `json:"type" binding:"required"`
+	AttestationKey string `json:"attestationKey"`
+	Actor          string `json:"actor" binding:"required"`
+}
+
+func (r RegisterWearableRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.identifier("deviceID", r.DeviceID)
+	v.oneOf("type", r.Type, wearableDeviceTypes())
+	if r.AttestationKey != "" {
+		if _, err := parseAttestationKey(r.AttestationKey); err != nil {
+			v.add("attestationKey", "must be a base64 Ed25519 public key")
+		}
+	}
+	v.identifier("actor", r.Actor)
+	return v.errors
+}
+
+// BindWearableRequest pairs a band with the tourist who will wear it
+type BindWearableRequest struct {
+	DigitalID string `json:"digitalID" binding:"required"`
+	Actor     string `json:"actor" binding:"required"`
+}
+
+func (r BindWearableRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.digitalID("digitalID", r.DigitalID)
+	v.identifier("actor", r.Actor)
+	return v.errors
+}
+
+func parseAttestationKey(encoded string) (ed25519.PublicKey, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, errors.New("not a base64 Ed25519 public key")
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+var errAttestationFailed = errors.New("signature does not match the band's attestation key")
+
+// attestedPayload unwraps a telemetry message. Bands with an attestation key send
+// {"data": <base64 payload>, "sig": <base64 Ed25519 signature of the payload>}, and their
+// unsigned or missigned messages are rejected; other bands send the payload as it is.
+func (d DeviceDocument) attestedPayload(message []byte) ([]byte, bool, error) {
+	var envelope struct {
+		Data string `json:"data"`
+		Sig  string `json:"sig"`
+	}
+	if json.Unmarshal(message, &envelope) != nil || envelope.Data == "" || envelope.Sig == "" {
+		if d.AttestationKey != "" {
+			return nil, false, errors.New("message is not signed")
+		}
+		return message, false, nil
+	}
+	payload, err := base64.StdEncoding.DecodeString(envelope.Data)
+	if err != nil {
+		return nil, false, errors.New("data must be base64")
+	}
+	if d.AttestationKey == "" {
+		return payload, false, nil
+	}
+	key, err := parseAttestationKey(d.AttestationKey)
+	if err != nil {
+		return nil, false, err
+	}
+	signature, err := base64.StdEncoding.DecodeString(envelope.Sig)
+	if err != nil || !ed25519.Verify(key, payload, signature) {
+		return nil, false, errAttestationFailed
+	}
+	return payload, true, nil
+}
+
+// deviceRegistry mirrors the default target's devices so each telemetry message is matched to its
+// tourist without a ledger read. It is reloaded every DEVICE_SYNC_INTERVAL and kept current by
+// chaincode events in between.
+type deviceRegistry struct {
+	mu       sync.RWMutex
+	devices  map[string]DeviceDocument
+	syncedAt time.Time
+}
+
+var devices *deviceRegistry
+
+func initDevices() {
+	devices = &deviceRegistry{devices: map[string]DeviceDocument{}}
+}
+
+// lookup returns a registered device. Until the first sync, a device missing from the registry is
+// read from the ledger, so telemetry arriving at startup is not dropped.
+func (r *deviceRegistry) lookup(ctx context.Context, deviceID string) (*DeviceDocument, error) {
+	r.mu.RLock()
+	device, ok := r.devices[deviceID]
+	synced := !r.syncedAt.IsZero()
+	r.mu.RUnlock()
+	if ok {
+		return &device, nil
+	}
+	if synced || len(validateDocumentID("deviceId", deviceID)) > 0 {
+		return nil, nil
+	}
+	return r.refresh(withTarget(ctx, defaultTarget), deviceID)
+}
+
+// refresh reads a device from the ledger into the registry
+func (r *deviceRegistry) refresh(ctx context.Context, deviceID string) (*DeviceDocument, error) {
+	device, err := ledger.deviceDocument(ctx, deviceID)
+	if err != nil {
+		if translateFabricError(err).Code == errCodeNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	r.put(device)
+	return &device, nil
+}
+
+func (r *deviceRegistry) put(device DeviceDocument) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.devices[device.DeviceID] = device
+}
+
+// run loads every device, retrying until it succeeds, then reloads every DEVICE_SYNC_INTERVAL to
+// recover from missed events
+func (r *deviceRegistry) run(ctx context.Context) {
+	interval := getEnvDuration("DEVICE_SYNC_INTERVAL", 5*time.Minute)
+	for {
+		wait := interval
+		if err := r.sync(ctx); err != nil {
+			log.Printf("Failed to load the device registry: %v", err)
+			wait = 10 * time.Second
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (r *deviceRegistry) sync(ctx context.Context) error {
+	result, err := evaluateTransaction(withTarget(ctx, defaultTarget), "GetAllDevices")
+	if err != nil {
+		return err
+	}
+	docs, err := decodeDocument[[]DeviceDocument](result, "device")
+	if err != nil {
+		return err
+	}
+	fresh := make(map[string]DeviceDocument, len(docs))
+	for _, doc := range docs {
+		fresh[doc.DeviceID] = doc
+	}
+
+	r.mu.Lock()
+	first := r.syncedAt.IsZero()
+	r.devices, r.syncedAt = fresh, time.Now().UTC()
+	r.mu.Unlock()
+	if first {
+		log.Printf("⌚ Loaded %d registered devices", len(docs))
+	}
+	return nil
+}
+
+// devicesFromEvent applies registrations and bindings from the default target, including ones made
+// through other gateway instances
+func devicesFromEvent(ctx context.Context, event *client.ChaincodeEvent) {
+	if devices == nil || !isDefaultTarget(ctx) {
+		return
+	}
+	switch event.EventName {
+	case "RegisterDevice", "BindDevice", "UnbindDevice":
+	default:
+		return
+	}
+	var device DeviceDocument
+	if err := json.Unmarshal(event.Payload, &device); err != nil || device.DeviceID == "" {
+		return
+	}
+	devices.put(device)
+}
+
+// Device operations
+
+func (ledgerService) RegisterDevice(ctx context.Context, req RegisterWearableRequest) (*TransactionResult, error) {
+	if errs := req.Validate(); len(errs) > 0 {
+		return nil, errs
+	}
+	result, err := submitAndInvalidate(ctx, req.DeviceID, "RegisterDevice", req.DeviceID, req.Type, req.AttestationKey, req.Actor)
+	if err != nil {
+		return nil, err
+	}
+	refreshDevice(ctx, req.DeviceID)
+	return result, nil
+}
+
+// BindDevice pairs a band with a tourist. A band must be unbound before it is given to another.
+func (ledgerService) BindDevice(ctx context.Context, deviceID string, req BindWearableRequest) (*TransactionResult, error) {
+	if err := validateMutation(deviceID, req); err != nil {
+		return nil, err
+	}
+	if _, err := ledger.GetDevice(ctx, deviceID); err != nil {
+		return nil, err
+	}
+	result, err := submitAndInvalidate(ctx, deviceID, "BindDevice", deviceID, req.DigitalID, req.Actor)
+	if err != nil {
+		return nil, err
+	}
+	refreshDevice(ctx, deviceID)
+	return result, nil
+}
+
+// UnbindDevice releases a band, after which its telemetry is dropped until it is bound again
+func (ledgerService) UnbindDevice(ctx context.Context, deviceID string, req DeleteRequest) (*TransactionResult, error) {
+	if err := validateMutation(deviceID, req); err != nil {
+		return nil, err
+	}
+	if _, err := ledger.GetDevice(ctx, deviceID); err != nil {
+		return nil, err
+	}
+	result, err := submitAndInvalidate(ctx, deviceID, "UnbindDevice", deviceID, req.Actor)
+	if err != nil {
+		return nil, err
+	}
+	refreshDevice(ctx, deviceID)
+	return result, nil
+}
+
+// refreshDevice updates the registry straight away rather than waiting for the event
+func refreshDevice(ctx context.Context, deviceID string) {
+	if devices == nil || !isDefaultTarget(ctx) {
+		return
+	}
+	if _, err := devices.refresh(ctx, deviceID); err != nil {
+		logWithContext(ctx, "⌚ Failed to reload device %s: %v", deviceID, err)
+	}
+}
+
+// GetDevice reads a device the caller's organization registered
+func (ledgerService) GetDevice(ctx context.Context, deviceID string) (DeviceDocument, error) {
+	if errs := validateDocumentID("deviceId", deviceID); len(errs) > 0 {
+		return DeviceDocument{}, errs
+	}
+	device, err := ledger.deviceDocument(ctx, deviceID)
+	if err == nil && !tenantFromContext(ctx).owns(device.OwnerOrg) {
+		return DeviceDocument{}, fmt.Errorf("the device %s does not exist", deviceID)
+	}
+	return device, err
+}
+
+func (ledgerService) deviceDocument(ctx context.Context, deviceID string) (DeviceDocument, error) {
+	result, err := readDocument(ctx, "ReadDevice", deviceID)
+	if err != nil {
+		return DeviceDocument{}, err
+	}
+	return decodeDocument[DeviceDocument](result, "device")
+}
+
+// ListDeviceBindings returns a device's bindings in sequence
+func (ledgerService) ListDeviceBindings(ctx context.Context, deviceID string) ([]DeviceBinding, error) {
+	if _, err := ledger.GetDevice(ctx, deviceID); err != nil {
+		return nil, err
+	}
+	result, err := evaluateTransaction(ctx, "GetDeviceBindings", deviceID)
+	if err != nil {
+		return nil, err
+	}
+	return decodeDocument[[]DeviceBinding](result, "device binding")
+}
+
+func registerWearable(c *gin.Context) {
+	var req RegisterWearableRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+	setAuditTarget(c, req.DeviceID)
+
+	result, err := ledger.RegisterDevice(c.Request.Context(), req)
+	if err != nil {
+		respondServiceError(c, "Failed to register device", err)
+		return
+	}
+	respondCommitted(c, http.StatusCreated, gin.H{
+		"message":  "Device registered successfully",
+		"deviceID": req.DeviceID,
+	}, result)
+}
+
+func getWearable(c *gin.Context) {
+	id, ok := validPathID(c, "deviceId")
+	if !ok {
+		return
+	}
+	device, err := ledger.GetDevice(c.Request.Context(), id)
+	if err != nil {
+		respondServiceError(c, "Failed to read device", err)
+		return
+	}
+	respondData(c, http.StatusOK, device)
+}
+
+func bindWearable(c *gin.Context) {
+	id, ok := validPathID(c, "deviceId")
+	if !ok {
+		return
+	}
+	var req BindWearableRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+	setAuditTarget(c, id)
+
+	result, err := ledger.BindDevice(c.Request.Context(), id, req)
+	if err != nil {
+		respondServiceError(c, "Failed to bind device", err)
+		return
+	}
+	respondCommitted(c, http.StatusOK, gin.H{
+		"message":   "Device bound successfully",
+		"deviceID":  id,
+		"digitalID": req.DigitalID,
+	}, result)
+}
+
+func unbindWearable(c *gin.Context) {
+	id, ok := validPathID(c, "deviceId")
+	if !ok {
+		return
+	}
+	var req DeleteRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+	setAuditTarget(c, id)
+
+	result, err := ledger.UnbindDevice(c.Request.Context(), id, req)
+	if err != nil {
+		respondServiceError(c, "Failed to unbind device", err)
+		return
+	}
+	respondCommitted(c, http.StatusOK, gin.H{
+		"message":  "Device unbound successfully",
+		"deviceID": id,
+	}, result)
+}
+
+func listWearableBindings(c *gin.Context) {
+	id, ok := validPathID(c, "deviceId")
+	if !ok {
+		return
+	}
+	bindings, err := ledger.ListDeviceBindings(c.Request.Context(), id)
+	if err != nil {
+		respondServiceError(c, "Failed to list device bindings", err)
+		return
+	}
+	respondData(c, http.StatusOK, bindings)
+}
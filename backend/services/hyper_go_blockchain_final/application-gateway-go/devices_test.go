@@ -0,0 +1,101 @@
+package gateway
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+func TestWearableAttestation(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	band := DeviceDocument{DeviceID: "band-0042", Type: "sihband", AttestationKey: base64.StdEncoding.EncodeToString(public), DigitalID: "did:sih:tourist_001", BindingID: "BIND:band-0042:3"}
+	w := &wearableService{store: newMemoryWearableStore(10, time.Hour)}
+	ctx := context.Background()
+	now := time.Now()
+	sign := func(payload string, key ed25519.PrivateKey) []byte {
+		envelope, _ := json.Marshal(map[string]string{
+			"data": base64.StdEncoding.EncodeToString([]byte(payload)),
+			"sig":  base64.StdEncoding.EncodeToString(ed25519.Sign(key, []byte(payload))),
+		})
+		return envelope
+	}
+	payload := fmt.Sprintf(`{"ts":%d,"bat":64}`, now.Add(-time.Minute).Unix())
+
+	reading, err := w.ingest(ctx, band, sign(payload, private), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reading.Attested || reading.BindingID != "BIND:band-0042:3" || *reading.Battery != 64 {
+		t.Errorf("unexpected reading %+v", reading)
+	}
+
+	// A band with a key must sign with it
+	_, other, _ := ed25519.GenerateKey(nil)
+	if _, err := w.ingest(ctx, band, sign(payload, other), now); err == nil {
+		t.Error("expected a reading signed with another key rejected")
+	}
+	if _, err := w.ingest(ctx, band, []byte(payload), now); err == nil {
+		t.Error("expected an unsigned reading rejected")
+	}
+
+	// A band without a key is trusted as before, unless attestation is required
+	band.AttestationKey = ""
+	if reading, err := w.ingest(ctx, band, []byte(fmt.Sprintf(`{"ts":%d,"bat":63}`, now.Unix())), now); err != nil || reading.Attested {
+		t.Errorf("expected an unattested reading accepted, got %+v, %v", reading, err)
+	}
+	w.requireAttestation = true
+	if _, err := w.ingest(ctx, band, []byte(payload), now); err == nil || !strings.Contains(err.Error(), "attestation") {
+		t.Errorf("expected the reading rejected for want of attestation, got %v", err)
+	}
+}
+
+func TestRegisterWearableValidation(t *testing.T) {
+	public, _, _ := ed25519.GenerateKey(nil)
+	valid := RegisterWearableRequest{DeviceID: "band-0042", Type: "sihband", AttestationKey: base64.StdEncoding.EncodeToString(public), Actor: "tourism_desk"}
+	if errs := valid.Validate(); len(errs) > 0 {
+		t.Fatalf("unexpected errors %v", errs)
+	}
+	short := valid
+	short.AttestationKey = base64.StdEncoding.EncodeToString(public[:16])
+	unknown := valid
+	unknown.Type = "fitbit"
+	for name, req := range map[string]RegisterWearableRequest{"short key": short, "unknown type": unknown} {
+		if errs := req.Validate(); len(errs) != 1 {
+			t.Errorf("%s: expected one error, got %v", name, errs)
+		}
+	}
+}
+
+func TestDevicesFromEvent(t *testing.T) {
+	previous := devices
+	devices = &deviceRegistry{devices: map[string]DeviceDocument{}, syncedAt: time.Now()}
+	defer func() { devices = previous }()
+	ctx := withTarget(context.Background(), defaultTarget)
+	event := func(name string, device DeviceDocument) *client.ChaincodeEvent {
+		payload, _ := json.Marshal(device)
+		return &client.ChaincodeEvent{EventName: name, Payload: payload}
+	}
+
+	devicesFromEvent(ctx, event("BindDevice", DeviceDocument{DeviceID: "band-0042", Type: "sihband", DigitalID: "did:sih:tourist_001", BindingID: "BIND:band-0042:1"}))
+	if device, _ := devices.lookup(ctx, "band-0042"); device == nil || device.DigitalID != "did:sih:tourist_001" {
+		t.Fatalf("expected the binding applied, got %+v", device)
+	}
+	devicesFromEvent(ctx, event("UnbindDevice", DeviceDocument{DeviceID: "band-0042", Type: "sihband", Bindings: 2}))
+	if device, _ := devices.lookup(ctx, "band-0042"); device == nil || device.DigitalID != "" || device.BindingID != "" {
+		t.Errorf("expected the band unbound, got %+v", device)
+	}
+	devicesFromEvent(ctx, event("CreateZone", DeviceDocument{DeviceID: "zone-1"}))
+	if device, _ := devices.lookup(ctx, "zone-1"); device != nil {
+		t.Errorf("expected other events ignored, got %+v", device)
+	}
+}
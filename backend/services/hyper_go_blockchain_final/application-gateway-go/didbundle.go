@@ -0,0 +1,264 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"assetTransfer/qrverify"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	errCodeDIDBundleDisabled = "DID_BUNDLE_DISABLED"
+
+	// defaultDeltaHistory is how many earlier versions of the revocation list and DID bundle a
+	// delta can start from
+	defaultDeltaHistory = 96
+)
+
+// maxDIDExpiry bounds the period exported for the bundle: every DID that has not expired
+var maxDIDExpiry = time.Date(9999, 12, 31, 23, 59, 59, 0, time.UTC)
+
+// OfflineListRequest asks for the revocation list or DID bundle. With Since set to a version the
+// device holds, the gateway answers with a delta from it when it still remembers that version.
+type OfflineListRequest struct {
+	Since int64 `form:"since"`
+}
+
+func (r OfflineListRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	if r.Since < 0 {
+		v.add("since", "must be a version the device holds")
+	}
+	return v.errors
+}
+
+// versionHistory remembers which entries changed at each recent version, so that a delta from any
+// of them can be built from the current entries alone
+type versionHistory struct {
+	size     int
+	versions []int64
+	changed  [][]string
+}
+
+func (h *versionHistory) record(version int64, changed []string) {
+	h.versions = append(h.versions, version)
+	h.changed = append(h.changed, changed)
+	if len(h.versions) > h.size+1 {
+		h.versions, h.changed = h.versions[1:], h.changed[1:]
+	}
+}
+
+// since returns the entries changed after version, sorted, or false when it is not remembered
+func (h *versionHistory) since(version int64) ([]string, bool) {
+	i := slices.Index(h.versions, version)
+	if i < 0 {
+		return nil, false
+	}
+	var changed []string
+	for _, entries := range h.changed[i+1:] {
+		changed = append(changed, entries...)
+	}
+	slices.Sort(changed)
+	return slices.Compact(changed), true
+}
+
+// changedEntries merges two sorted lists and returns the keys of the entries added, removed or
+// altered between them
+func changedEntries[T comparable](old, new []T, key func(T) string) []string {
+	var changed []string
+	i, j := 0, 0
+	for i < len(old) || j < len(new) {
+		switch {
+		case j == len(new) || (i < len(old) && key(old[i]) < key(new[j])):
+			changed = append(changed, key(old[i]))
+			i++
+		case i == len(old) || key(new[j]) < key(old[i]):
+			changed = append(changed, key(new[j]))
+			j++
+		default:
+			if old[i] != new[j] {
+				changed = append(changed, key(new[j]))
+			}
+			i++
+			j++
+		}
+	}
+	return changed
+}
+
+// nextVersion keeps a list's version while its entries are unchanged, and otherwise moves it to
+// the current Unix time, or past the previous version when the clock is behind
+func nextVersion(previous int64, changed bool, now time.Time) int64 {
+	if previous == 0 {
+		return now.Unix()
+	}
+	if !changed {
+		return previous
+	}
+	return max(now.Unix(), previous+1)
+}
+
+// didBundlePublisher periodically signs the set of unexpired DIDs for police devices to check a
+// DID against without a network, when the tourist has no QR code to show
+type didBundlePublisher struct {
+	interval time.Duration
+	validity time.Duration
+
+	mu      sync.RWMutex
+	bundle  *qrverify.Bundle
+	token   string
+	digest  string
+	history versionHistory
+}
+
+var didBundles *didBundlePublisher
+
+// initDIDBundle enables the DID bundle when DID_BUNDLE_ENABLED is set. Building it reads every DID
+// on the ledger, so it is off by default.
+func initDIDBundle() {
+	if !getEnvBool("DID_BUNDLE_ENABLED", false) {
+		log.Println("📇 DID_BUNDLE_ENABLED not set, the offline DID bundle is disabled")
+		return
+	}
+	b := &didBundlePublisher{
+		interval: getEnvDuration("DID_BUNDLE_INTERVAL", time.Hour),
+		validity: getEnvDuration("DID_BUNDLE_VALIDITY", 72*time.Hour),
+		history:  versionHistory{size: getEnvInt("DID_BUNDLE_HISTORY", defaultDeltaHistory)},
+	}
+	if b.interval <= 0 || b.validity <= b.interval {
+		panic(fmt.Errorf("DID_BUNDLE_VALIDITY must exceed a positive DID_BUNDLE_INTERVAL"))
+	}
+	if b.history.size < 0 {
+		panic(fmt.Errorf("DID_BUNDLE_HISTORY must not be negative"))
+	}
+	didBundles = b
+	log.Printf("📇 Publishing the signed DID bundle every %s, valid for %s", b.interval, b.validity)
+}
+
+// publish re-signs the bundle from the ledger, keeping the version unless the entries changed.
+// Like the revocation list, it looks past the caller's tenancy.
+func (b *didBundlePublisher) publish(ctx context.Context, now time.Time) error {
+	dids, err := exportedInPeriod(ctx, "did", now, maxDIDExpiry, func(d DIDDocument) string { return d.ExpiresAt })
+	if err != nil {
+		return err
+	}
+	entries := make([]qrverify.BundleEntry, 0, len(dids))
+	for _, did := range dids {
+		entries = append(entries, qrverify.BundleEntry{Entry: qrverify.RevocationEntry(did.DigitalID), Issuer: did.Issuer, ExpiresAt: did.ExpiresAt})
+	}
+	slices.SortFunc(entries, func(a, b qrverify.BundleEntry) int { return strings.Compare(a.Entry, b.Entry) })
+	entries = slices.CompactFunc(entries, func(a, b qrverify.BundleEntry) bool { return a.Entry == b.Entry })
+	return b.sign(entries, now)
+}
+
+func (b *didBundlePublisher) sign(entries []qrverify.BundleEntry, now time.Time) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var previous []qrverify.BundleEntry
+	version := int64(0)
+	if b.bundle != nil {
+		previous, version = b.bundle.DIDs, b.bundle.Version
+	}
+	changed := changedEntries(previous, entries, func(e qrverify.BundleEntry) string { return e.Entry })
+	version = nextVersion(version, len(changed) > 0, now)
+	bundle := &qrverify.Bundle{
+		Version:   version,
+		IssuedAt:  now.UTC().Format(time.RFC3339),
+		ExpiresAt: now.Add(b.validity).UTC().Format(time.RFC3339),
+		DIDs:      entries,
+	}
+	token, err := didQRSigner.signJWT(qrverify.BundleType, didQRSigner.keyID, bundle)
+	if err != nil {
+		return err
+	}
+	if b.bundle == nil || version != b.bundle.Version {
+		b.history.record(version, changed)
+		log.Printf("📇 DID bundle version %d lists %d DIDs, %d changed", version, len(entries), len(changed))
+	}
+	b.bundle, b.token, b.digest = bundle, token, qrverify.BundleDigest(entries)
+	return nil
+}
+
+// current returns the latest signed bundle, or nil before the first is published
+func (b *didBundlePublisher) current() (*qrverify.Bundle, string) {
+	if b == nil {
+		return nil, ""
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.bundle, b.token
+}
+
+// delta signs the changes from an earlier version to the current bundle, or returns false when
+// that version is not remembered. A delta from the current version only renews the expiry.
+func (b *didBundlePublisher) delta(from int64) (string, bool, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	changed, ok := b.history.since(from)
+	if !ok || b.bundle == nil {
+		return "", false, nil
+	}
+	delta := qrverify.BundleDelta{
+		From:      from,
+		Version:   b.bundle.Version,
+		IssuedAt:  b.bundle.IssuedAt,
+		ExpiresAt: b.bundle.ExpiresAt,
+		Digest:    b.digest,
+	}
+	for _, entry := range changed {
+		i, found := slices.BinarySearchFunc(b.bundle.DIDs, entry, func(e qrverify.BundleEntry, id string) int { return strings.Compare(e.Entry, id) })
+		if found {
+			delta.Upserted = append(delta.Upserted, b.bundle.DIDs[i])
+		} else {
+			delta.Removed = append(delta.Removed, entry)
+		}
+	}
+	token, err := didQRSigner.signJWT(qrverify.BundleDeltaType, didQRSigner.keyID, delta)
+	return token, true, err
+}
+
+// getDIDBundle serves the signed DID bundle, or a delta from the version the device holds
+func getDIDBundle(c *gin.Context) {
+	var req OfflineListRequest
+	if !bindQuery(c, &req) {
+		return
+	}
+	if didBundles == nil {
+		respondError(c, http.StatusServiceUnavailable, errCodeDIDBundleDisabled, "The offline DID bundle is not enabled")
+		return
+	}
+	bundle, token := didBundles.current()
+	if bundle == nil {
+		respondError(c, http.StatusServiceUnavailable, errCodeUnavailable, "The DID bundle has not been published yet")
+		return
+	}
+	if req.Since > 0 {
+		delta, ok, err := didBundles.delta(req.Since)
+		if err != nil {
+			respondServiceError(c, "Failed to sign the DID bundle delta", err)
+			return
+		}
+		if ok {
+			c.Header("X-DID-Bundle-From", strconv.FormatInt(req.Since, 10))
+			token = delta
+		}
+	}
+	c.Header("Cache-Control", "private, max-age=60")
+	c.Header("X-DID-Bundle-Version", strconv.FormatInt(bundle.Version, 10))
+	c.Data(http.StatusOK, "application/jwt", []byte(token))
+}
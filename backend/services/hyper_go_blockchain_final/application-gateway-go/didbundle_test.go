@@ -0,0 +1,102 @@
+package gateway
+
+import (
+	"crypto/ed25519"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"assetTransfer/qrverify"
+)
+
+func TestOfflineListDeltas(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := didQRSigner
+	didQRSigner = newQRSigner(key)
+	defer func() { didQRSigner = saved }()
+	keys := didQRSigner.keys()
+
+	b := &didBundlePublisher{validity: time.Hour, history: versionHistory{size: 2}}
+	entry := func(did, expires string) qrverify.BundleEntry {
+		return qrverify.BundleEntry{Entry: qrverify.RevocationEntry(did), Issuer: "tourism_authority", ExpiresAt: expires}
+	}
+	sorted := func(entries ...qrverify.BundleEntry) []qrverify.BundleEntry {
+		slices.SortFunc(entries, func(a, b qrverify.BundleEntry) int { return strings.Compare(a.Entry, b.Entry) })
+		return entries
+	}
+	now := time.Date(2026, 10, 16, 10, 0, 0, 0, time.UTC)
+	one, two, three := entry("did:sih:t1", "2027-01-01T00:00:00Z"), entry("did:sih:t2", "2027-01-01T00:00:00Z"), entry("did:sih:t3", "2027-01-01T00:00:00Z")
+
+	if err := b.sign(sorted(one, two), now); err != nil {
+		t.Fatal(err)
+	}
+	_, token := b.current()
+	first, err := qrverify.ParseBundle(token, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Re-signing unchanged entries keeps the version
+	if err := b.sign(sorted(one, two), now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if bundle, _ := b.current(); bundle.Version != first.Version {
+		t.Errorf("expected the version kept, got %d after %d", bundle.Version, first.Version)
+	}
+	// t2 is deleted, then t1's expiry extended and t3 issued
+	if err := b.sign(sorted(one), now.Add(2*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	one.ExpiresAt = "2028-01-01T00:00:00Z"
+	if err := b.sign(sorted(one, three), now.Add(3*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	token, ok, err := b.delta(first.Version)
+	if err != nil || !ok {
+		t.Fatalf("expected a delta from the first version, got %v, %v", ok, err)
+	}
+	delta, err := qrverify.ParseBundleDelta(token, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	updated, err := first.Apply(delta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, _ := b.current()
+	if !slices.Equal(updated.DIDs, current.DIDs) || updated.Version != current.Version || len(delta.Upserted) != 2 || len(delta.Removed) != 1 {
+		t.Errorf("expected the delta to reach the current bundle, got %+v from %+v", updated, delta)
+	}
+
+	// A fourth version pushes the first out of a history of two
+	if err := b.sign(sorted(three), now.Add(4*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := b.delta(first.Version); ok {
+		t.Error("expected no delta from a forgotten version")
+	}
+
+	p := &revocationPublisher{validity: time.Hour, history: versionHistory{size: 2}}
+	if err := p.sign([]string{"a", "c"}, now); err != nil {
+		t.Fatal(err)
+	}
+	list, _ := p.current()
+	if err := p.sign([]string{"a", "b"}, now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	token, ok, err = p.delta(list.Version)
+	if err != nil || !ok {
+		t.Fatalf("expected a revocation list delta, got %v, %v", ok, err)
+	}
+	listDelta, err := qrverify.ParseRevocationDelta(token, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if applied, err := list.Apply(listDelta); err != nil || !slices.Equal(applied.Revoked, []string{"a", "b"}) {
+		t.Errorf("expected the revocation delta applied, got %+v, %v", applied, err)
+	}
+}
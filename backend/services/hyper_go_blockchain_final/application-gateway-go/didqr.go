@@ -0,0 +1,297 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"assetTransfer/qrverify"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	errCodeDIDNotValid = "DID_NOT_VALID"
+	qrPayloadPrefix    = qrverify.PayloadPrefix
+	maxQRPayloadBytes  = 2048
+)
+
+// QR verification failures; the messages double as verdict reasons
+var (
+	errQRMalformed    = qrverify.ErrMalformed
+	errQRUnknownKey   = qrverify.ErrUnknownKey
+	errQRBadSignature = qrverify.ErrBadSignature
+)
+
+// DIDQRClaims are the fields signed into a DID QR code, shared with the offline verifier
+type DIDQRClaims = qrverify.Claims
+
+// VerifyQRRequest carries the text scanned from a DID QR code
+type VerifyQRRequest struct {
+	Payload string `json:"payload" binding:"required"`
+}
+
+// QRVerification is the verdict on a scanned DID QR code. Valid requires a good signature, an
+// unexpired payload and a DID that still verifies on the ledger.
+type QRVerification struct {
+	Valid          bool             `json:"valid"`
+	SignatureValid bool             `json:"signature_valid"`
+	Expired        bool             `json:"expired"`
+	Reason         string           `json:"reason,omitempty"`
+	Claims         *DIDQRClaims     `json:"claims,omitempty"`
+	Ledger         *DIDVerification `json:"ledger,omitempty"`
+}
+
+// qrSigner signs DID QR payloads with the gateway's Ed25519 key
+type qrSigner struct {
+	key   ed25519.PrivateKey
+	keyID string
+}
+
+var didQRSigner *qrSigner
+
+// initQRSigner loads QR_SIGNING_KEY_FILE, or generates a key that only lasts until restart
+func initQRSigner() {
+	path := getEnv("QR_SIGNING_KEY_FILE", "")
+	if path == "" {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			panic(fmt.Errorf("failed to generate QR signing key: %w", err))
+		}
+		didQRSigner = newQRSigner(key)
+		log.Printf("⚠️  QR_SIGNING_KEY_FILE not set; DID QR codes are signed with an ephemeral key (%s)", didQRSigner.keyID)
+		return
+	}
+
+	signer, err := loadQRSigner(path)
+	if err != nil {
+		panic(fmt.Errorf("failed to load QR signing key: %w", err))
+	}
+	didQRSigner = signer
+	log.Printf("🔏 Signing DID QR codes with key %s", signer.keyID)
+}
+
+func loadQRSigner(path string) (*qrSigner, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s does not contain a PEM block", path)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an Ed25519 private key", path)
+	}
+	return newQRSigner(key), nil
+}
+
+func newQRSigner(key ed25519.PrivateKey) *qrSigner {
+	return &qrSigner{key: key, keyID: qrverify.KeyID(key.Public().(ed25519.PublicKey))}
+}
+
+// keys are the keys the signer's payloads verify against
+func (s *qrSigner) keys() qrverify.Keys {
+	return qrverify.Keys{s.keyID: s.key.Public().(ed25519.PublicKey)}
+}
+
+// sign produces SIH1.<claims>.<signature>, both base64url encoded, with the signature covering
+// everything before the last dot
+func (s *qrSigner) sign(claims DIDQRClaims) (string, error) {
+	claims.KeyID = s.keyID
+	body, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signed := qrPayloadPrefix + base64.RawURLEncoding.EncodeToString(body)
+	signature := ed25519.Sign(s.key, []byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// publicJWK is the signer's Ed25519 public key as a JSON Web Key identified by kid
+func (s *qrSigner) publicJWK(kid string) gin.H {
+	return gin.H{
+		"kty": "OKP",
+		"crv": "Ed25519",
+		"alg": "EdDSA",
+		"use": "sig",
+		"kid": kid,
+		"x":   base64.RawURLEncoding.EncodeToString(s.key.Public().(ed25519.PublicKey)),
+	}
+}
+
+// signJWT encodes claims as a compact JWS signed with EdDSA, naming the key as kid
+func (s *qrSigner) signJWT(typ, kid string, claims interface{}) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "EdDSA", "typ": typ, "kid": kid})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	signature := ed25519.Sign(s.key, []byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// verifyJWT checks a compact JWS signed by this key under kid and returns its payload. The
+// payload is returned with an unknown key or a bad signature too, for the verdict to describe.
+func (s *qrSigner) verifyJWT(token, kid string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errCredentialMalformed
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(headerJSON, &header) != nil || header.Alg != "EdDSA" {
+		return nil, errCredentialMalformed
+	}
+	var claims map[string]interface{}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || json.Unmarshal(payload, &claims) != nil {
+		return nil, errCredentialMalformed
+	}
+	if header.Kid != kid {
+		return claims, errCredentialUnknownKey
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !ed25519.Verify(s.key.Public().(ed25519.PublicKey), []byte(parts[0]+"."+parts[1]), signature) {
+		return claims, errCredentialBadSignature
+	}
+	return claims, nil
+}
+
+// verify checks a payload's signature and returns its claims
+func (s *qrSigner) verify(payload string) (*DIDQRClaims, error) {
+	return qrverify.ParsePayload(payload, s.keys())
+}
+
+// IssueDIDQR signs a QR payload for a DID that currently verifies on the ledger, embedding the
+// version of the current revocation list so offline verifiers ignore older entries for the DID
+func (ledgerService) IssueDIDQR(ctx context.Context, id string) (string, DIDVerification, error) {
+	verdict, err := ledger.VerifyDID(ctx, id)
+	if err != nil || !verdict.Valid {
+		return "", verdict, err
+	}
+
+	payload, err := didQRSigner.sign(DIDQRClaims{
+		DigitalID:             verdict.DigitalID,
+		Issuer:                verdict.Issuer,
+		ExpiresAt:             verdict.ExpiresAt,
+		IssuedAt:              time.Now().UTC().Format(time.RFC3339),
+		RevocationListVersion: qrRevocations.version(),
+	})
+	return payload, verdict, err
+}
+
+// VerifyDIDQR checks a scanned payload's signature and expiry, then re-verifies the DID on the ledger
+func (ledgerService) VerifyDIDQR(ctx context.Context, payload string) (QRVerification, error) {
+	if len(payload) > maxQRPayloadBytes {
+		return QRVerification{}, ValidationErrors{{Field: "payload", Message: fmt.Sprintf("must be at most %d bytes", maxQRPayloadBytes)}}
+	}
+
+	claims, err := didQRSigner.verify(payload)
+	if err != nil {
+		return QRVerification{Reason: err.Error(), Claims: claims}, nil
+	}
+
+	result := QRVerification{SignatureValid: true, Claims: claims}
+	if expiresAt, err := time.Parse(time.RFC3339, claims.ExpiresAt); err != nil || !expiresAt.After(time.Now()) {
+		result.Expired = true
+		result.Reason = "expired"
+		return result, nil
+	}
+
+	verdict, err := ledger.VerifyDID(ctx, claims.DigitalID)
+	if err != nil {
+		return QRVerification{}, err
+	}
+	result.Ledger = &verdict
+	result.Valid = verdict.Valid
+	result.Reason = verdict.Reason
+	return result, nil
+}
+
+// getDIDQR renders a signed QR code for printing on entry permits, as PNG by default or SVG
+func getDIDQR(c *gin.Context) {
+	format := c.DefaultQuery("format", "png")
+	scale, err := strconv.Atoi(c.DefaultQuery("scale", "8"))
+	var v fieldValidator
+	v.oneOf("format", format, []string{"png", "svg"})
+	if err != nil || scale < 1 || scale > 32 {
+		v.add("scale", "must be an integer between 1 and 32")
+	}
+	if len(v.errors) > 0 {
+		respondValidationErrors(c, v.errors)
+		return
+	}
+
+	payload, verdict, err := ledger.IssueDIDQR(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		respondServiceError(c, "Failed to issue DID QR code", err)
+		return
+	}
+	if payload == "" {
+		respondError(c, http.StatusConflict, errCodeDIDNotValid, fmt.Sprintf("DID %s is not valid: %s", verdict.DigitalID, verdict.Reason))
+		return
+	}
+
+	qr, err := encodeQR([]byte(payload))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+
+	c.Header("X-QR-Payload", payload)
+	if format == "svg" {
+		c.Data(http.StatusOK, "image/svg+xml", qr.SVG())
+		return
+	}
+	image, err := qr.PNG(scale)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errCodeInternal, "Failed to render QR code")
+		return
+	}
+	c.Data(http.StatusOK, "image/png", image)
+}
+
+func verifyDIDQR(c *gin.Context) {
+	var req VerifyQRRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+
+	result, err := ledger.VerifyDIDQR(c.Request.Context(), req.Payload)
+	if err != nil {
+		respondServiceError(c, "Failed to verify DID QR code", err)
+		return
+	}
+
+	respondData(c, http.StatusOK, result)
+}
@@ -0,0 +1,226 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Media types of the DID Resolution HTTP(S) binding
+const (
+	didResolutionMediaType = `application/ld+json;profile="https://w3id.org/did-resolution"`
+	didLDJSONMediaType     = "application/did+ld+json"
+	didJSONMediaType       = "application/did+json"
+
+	didCoreContext       = "https://www.w3.org/ns/did/v1"
+	didResolutionContext = "https://w3id.org/did-resolution/v1"
+	jsonWebKeyContext    = "https://w3id.org/security/suites/jws-2020/v1"
+)
+
+// DID Resolution error codes, reported in didResolutionMetadata.error
+const (
+	didErrInvalidDID         = "invalidDid"
+	didErrNotFound           = "notFound"
+	didErrMethodNotSupported = "methodNotSupported"
+	didErrRepresentation     = "representationNotSupported"
+	didErrInternal           = "internalError"
+)
+
+// ResolvedDIDDocument is a DID document built from a ledger DID record. Tourist DIDs carry no keys on
+// the ledger, so only the gateway's own issuer DID lists verification methods.
+type ResolvedDIDDocument struct {
+	Context            []string             `json:"@context,omitempty"`
+	ID                 string               `json:"id"`
+	Controller         string               `json:"controller,omitempty"`
+	VerificationMethod []VerificationMethod `json:"verificationMethod,omitempty"`
+	AssertionMethod    []string             `json:"assertionMethod,omitempty"`
+}
+
+// VerificationMethod is a public key in a DID document
+type VerificationMethod struct {
+	ID           string `json:"id"`
+	Type         string `json:"type"`
+	Controller   string `json:"controller"`
+	PublicKeyJwk gin.H  `json:"publicKeyJwk"`
+}
+
+// DIDResolutionMetadata describes the resolution itself
+type DIDResolutionMetadata struct {
+	ContentType string `json:"contentType,omitempty"`
+	Error       string `json:"error,omitempty"`
+	Retrieved   string `json:"retrieved"`
+	Duration    int64  `json:"duration"`
+	Method      string `json:"method,omitempty"`
+}
+
+// DIDDocumentMetadata describes the resolved document. Expires is not a DID Core property; it
+// carries the ledger record's expiry so verifiers need not make a second call.
+type DIDDocumentMetadata struct {
+	Created     string `json:"created,omitempty"`
+	Deactivated bool   `json:"deactivated,omitempty"`
+	VersionID   string `json:"versionId,omitempty"`
+	Expires     string `json:"expires,omitempty"`
+}
+
+// DIDResolutionResult is the response of the DID Resolution HTTP(S) binding
+type DIDResolutionResult struct {
+	Context               string                `json:"@context"`
+	DIDDocument           *ResolvedDIDDocument  `json:"didDocument"`
+	DIDResolutionMetadata DIDResolutionMetadata `json:"didResolutionMetadata"`
+	DIDDocumentMetadata   DIDDocumentMetadata   `json:"didDocumentMetadata"`
+}
+
+// didResolver resolves the DIDs of one method from the ledger
+type didResolver struct {
+	method string
+}
+
+var didResolution *didResolver
+
+func initDIDResolver() {
+	if !getEnvBool("DID_RESOLVER_ENABLED", false) {
+		log.Println("🔎 DID_RESOLVER_ENABLED not set, DID resolution disabled")
+		return
+	}
+	didResolution = &didResolver{method: getEnv("DID_RESOLVER_METHOD", "sih")}
+	log.Printf("🔎 Resolving did:%s identifiers at /1.0/identifiers", didResolution.method)
+}
+
+// resolve returns the resolution result for a DID and the HTTP status the binding assigns to it
+func (r *didResolver) resolve(ctx context.Context, did string) (DIDResolutionResult, int) {
+	started := time.Now()
+	result := DIDResolutionResult{Context: didResolutionContext}
+	status := r.resolveInto(ctx, did, &result)
+	result.DIDResolutionMetadata.Retrieved = started.UTC().Format(time.RFC3339)
+	result.DIDResolutionMetadata.Duration = time.Since(started).Milliseconds()
+	return result, status
+}
+
+func (r *didResolver) resolveInto(ctx context.Context, did string, result *DIDResolutionResult) int {
+	meta := &result.DIDResolutionMetadata
+	if !digitalIDRegex.MatchString(did) {
+		meta.Error = didErrInvalidDID
+		return http.StatusBadRequest
+	}
+	meta.Method = strings.SplitN(did, ":", 3)[1]
+	if meta.Method != r.method {
+		meta.Error = didErrMethodNotSupported
+		return http.StatusNotImplemented
+	}
+
+	// The gateway's issuer DID is not a ledger record; it is resolved from the signing key
+	if vcIssuer != nil && did == vcIssuer.issuerDID {
+		result.DIDDocument = vcIssuer.didDocument()
+		return http.StatusOK
+	}
+
+	verdict, err := ledger.VerifyDID(ctx, did)
+	if err != nil {
+		logWithContext(ctx, "Failed to resolve %s: %v", did, err)
+		meta.Error = didErrInternal
+		return http.StatusInternalServerError
+	}
+	switch {
+	case verdict.Revoked:
+		// Deleting a DID is how the ledger revokes it, which DID Resolution calls deactivation
+		result.DIDDocumentMetadata = DIDDocumentMetadata{Deactivated: true, VersionID: verdict.TxID}
+		return http.StatusGone
+	case !verdict.Exists:
+		meta.Error = didErrNotFound
+		return http.StatusNotFound
+	}
+
+	result.DIDDocument = &ResolvedDIDDocument{Context: []string{didCoreContext}, ID: did}
+	if digitalIDRegex.MatchString(verdict.Issuer) {
+		result.DIDDocument.Controller = verdict.Issuer
+	}
+	result.DIDDocumentMetadata = DIDDocumentMetadata{
+		Created:   verdict.IssuedAt,
+		VersionID: verdict.TxID,
+		Expires:   verdict.ExpiresAt,
+	}
+	return http.StatusOK
+}
+
+// didDocument lists the issuer key that signs credentials, under the kid their JWT headers carry
+func (ci *credentialIssuer) didDocument() *ResolvedDIDDocument {
+	method := ci.verificationMethod()
+	return &ResolvedDIDDocument{
+		Context: []string{didCoreContext, jsonWebKeyContext},
+		ID:      ci.issuerDID,
+		VerificationMethod: []VerificationMethod{{
+			ID:           method,
+			Type:         "JsonWebKey2020",
+			Controller:   ci.issuerDID,
+			PublicKeyJwk: ci.publicJWK(),
+		}},
+		AssertionMethod: []string{method},
+	}
+}
+
+// didRepresentation picks the representation an Accept header asks for: the full resolution
+// result, or the DID document alone as JSON-LD or plain JSON
+func didRepresentation(accept string) (string, bool) {
+	if accept == "" {
+		return didResolutionMediaType, true
+	}
+	for _, part := range strings.Split(accept, ",") {
+		mediaType := strings.ToLower(strings.TrimSpace(strings.SplitN(part, ";", 2)[0]))
+		switch mediaType {
+		case "application/ld+json", "application/json", "*/*", "application/*":
+			return didResolutionMediaType, true
+		case didLDJSONMediaType, didJSONMediaType:
+			return mediaType, true
+		}
+	}
+	return "", false
+}
+
+// resolveIdentifier implements GET /1.0/identifiers/{did} of the DID Resolution HTTP(S) binding
+func resolveIdentifier(c *gin.Context) {
+	if didResolution == nil {
+		respondError(c, http.StatusNotFound, errCodeNotFound, "DID resolution is not enabled")
+		return
+	}
+	contentType, ok := didRepresentation(c.GetHeader("Accept"))
+	if !ok {
+		result := DIDResolutionResult{Context: didResolutionContext}
+		result.DIDResolutionMetadata.Error = didErrRepresentation
+		result.DIDResolutionMetadata.Retrieved = time.Now().UTC().Format(time.RFC3339)
+		c.Header("Content-Type", didResolutionMediaType)
+		c.JSON(http.StatusNotAcceptable, result)
+		return
+	}
+	result, status := didResolution.resolve(c.Request.Context(), c.Param("did"))
+
+	if status < http.StatusInternalServerError {
+		c.Header("Cache-Control", "max-age=60")
+	}
+	if contentType == didResolutionMediaType || result.DIDDocument == nil {
+		// gin keeps a Content-Type that is already set
+		c.Header("Content-Type", didResolutionMediaType)
+		if result.DIDDocument != nil {
+			result.DIDResolutionMetadata.ContentType = didLDJSONMediaType
+		}
+		c.JSON(status, result)
+		return
+	}
+
+	document := *result.DIDDocument
+	if contentType == didJSONMediaType {
+		// The plain JSON representation has no @context
+		document.Context = nil
+	}
+	c.Header("Content-Type", contentType)
+	c.JSON(status, document)
+}
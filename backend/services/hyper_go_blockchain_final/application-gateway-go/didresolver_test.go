@@ -0,0 +1,85 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestDIDRepresentation(t *testing.T) {
+	for accept, want := range map[string]string{
+		"":                                    didResolutionMediaType,
+		"*/*":                                 didResolutionMediaType,
+		didResolutionMediaType:                didResolutionMediaType,
+		"application/did+ld+json":             didLDJSONMediaType,
+		"text/html, application/did+json":     didJSONMediaType,
+		"application/did+json;q=0.9, */*;q=0": didJSONMediaType,
+	} {
+		if got, ok := didRepresentation(accept); !ok || got != want {
+			t.Errorf("%q: expected %s, got %s", accept, want, got)
+		}
+	}
+	if _, ok := didRepresentation("text/html"); ok {
+		t.Error("expected text/html refused")
+	}
+}
+
+func TestResolveIdentifier(t *testing.T) {
+	vcIssuer = testCredentialIssuer(t)
+	didResolution = &didResolver{method: "sih"}
+	defer func() { vcIssuer, didResolution = nil, nil }()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/1.0/identifiers/:did", resolveIdentifier)
+	resolve := func(did, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/1.0/identifiers/"+did, nil)
+		req.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	for did, tt := range map[string]struct {
+		status int
+		error  string
+	}{
+		"not-a-did":           {http.StatusBadRequest, didErrInvalidDID},
+		"did:web:example.org": {http.StatusNotImplemented, didErrMethodNotSupported},
+	} {
+		w := resolve(did, "")
+		var result DIDResolutionResult
+		json.Unmarshal(w.Body.Bytes(), &result)
+		if w.Code != tt.status || result.DIDResolutionMetadata.Error != tt.error || result.DIDDocument != nil {
+			t.Errorf("%s: expected %d %s, got %d %s", did, tt.status, tt.error, w.Code, w.Body.String())
+		}
+	}
+
+	w := resolve("did:sih:issuer", "")
+	var result DIDResolutionResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("expected the issuer DID resolved, got %d %s", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("Content-Type"); got != didResolutionMediaType {
+		t.Errorf("expected a resolution result, got %s", got)
+	}
+	doc := result.DIDDocument
+	if doc == nil || len(doc.VerificationMethod) != 1 || doc.VerificationMethod[0].ID != vcIssuer.verificationMethod() ||
+		len(doc.AssertionMethod) != 1 || doc.AssertionMethod[0] != vcIssuer.verificationMethod() {
+		t.Errorf("expected the credential signing key as an assertion method, got %+v", doc)
+	}
+
+	w = resolve("did:sih:issuer", didJSONMediaType)
+	var plain map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &plain)
+	if w.Header().Get("Content-Type") != didJSONMediaType || plain["id"] != "did:sih:issuer" || plain["@context"] != nil {
+		t.Errorf("expected a plain JSON DID document, got %s %s", w.Header().Get("Content-Type"), w.Body.String())
+	}
+
+	if w = resolve("did:sih:issuer", "text/html"); w.Code != http.StatusNotAcceptable {
+		t.Errorf("expected 406, got %d", w.Code)
+	}
+}
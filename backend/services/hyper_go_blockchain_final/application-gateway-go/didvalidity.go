@@ -0,0 +1,308 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+const (
+	defaultDIDValidityMaxAge = 10 * time.Minute
+	defaultDIDValiditySize   = 500000
+	didValidityWarmInterval  = 15 * time.Second
+)
+
+// didValidity is what verifying a DID needs to know of it
+type didValidity struct {
+	exists    bool
+	revoked   bool
+	issuer    string
+	issuedAt  string
+	expiresAt string
+	txID      string
+	// block is the block the state changed in or, for a state read from the peers, the channel
+	// height when the event stream started, as the read is at least that recent
+	block    uint64
+	loadedAt time.Time
+}
+
+func didValidityOf(doc DIDDocument) didValidity {
+	return didValidity{exists: true, issuer: doc.Issuer, issuedAt: doc.IssuedAt, expiresAt: doc.ExpiresAt, txID: doc.TxID}
+}
+
+// verdict checks the expiry at now, so a cached state never vouches for an expired DID
+func (s didValidity) verdict(id string, now time.Time) DIDVerification {
+	verdict := DIDVerification{DigitalID: id, VerifiedAt: now.UTC().Format(time.RFC3339), TxID: s.txID, Source: "ledger"}
+	switch {
+	case s.revoked:
+		verdict.Revoked = true
+		verdict.Reason = "revoked"
+		return verdict
+	case !s.exists:
+		verdict.Reason = "not_found"
+		return verdict
+	}
+	verdict.Exists = true
+	verdict.Issuer = s.issuer
+	verdict.IssuedAt = s.issuedAt
+	verdict.ExpiresAt = s.expiresAt
+
+	expiresAt, err := time.Parse(time.RFC3339, s.expiresAt)
+	switch {
+	case err != nil:
+		verdict.Reason = "invalid_expiry"
+	case !expiresAt.After(now):
+		verdict.Expired = true
+		verdict.Reason = "expired"
+	default:
+		verdict.Valid = true
+	}
+	return verdict
+}
+
+// didValidityCache answers DID verifications from memory, as checkpoints verify tens of thousands
+// of DIDs an hour in peak season. It is warmed from the off-chain index, filled from the peers on
+// a miss and kept current by the DID events of the default target. It only answers while this
+// replica's event stream runs: when the stream stops, events may be missed, so the cache empties
+// and starts again with the next stream. Every state is re-read from the peers once it is older
+// than DID_VALIDITY_CACHE_MAX_AGE, which bounds how stale an answer can be.
+type didValidityCache struct {
+	maxAge time.Duration
+	size   int
+
+	mu      sync.Mutex
+	entries map[string]didValidity
+	live    bool
+	// generation changes whenever the stream starts or stops, so reads begun before are dropped
+	generation uint64
+	// startHeight is the channel height when the stream started; warming waits for the index to
+	// reach it, as earlier changes may never arrive as events
+	startHeight uint64
+	warmed      bool
+}
+
+var didValidities *didValidityCache
+
+// initDIDValidityCache runs after initOffchainIndex. Without DID_VALIDITY_CACHE every verification
+// reads from the peers.
+func initDIDValidityCache() {
+	if !getEnvBool("DID_VALIDITY_CACHE", false) {
+		return
+	}
+	c := &didValidityCache{
+		maxAge: getEnvDuration("DID_VALIDITY_CACHE_MAX_AGE", defaultDIDValidityMaxAge),
+		size:   getEnvInt("DID_VALIDITY_CACHE_SIZE", defaultDIDValiditySize),
+	}
+	if c.maxAge <= 0 || c.size <= 0 {
+		panic(errors.New("DID_VALIDITY_CACHE_MAX_AGE and DID_VALIDITY_CACHE_SIZE must be positive"))
+	}
+	didValidities = c
+	log.Printf("🔖 Caching up to %d DID validity states, each for at most %s", c.size, c.maxAge)
+}
+
+// resume starts the cache afresh when the default target's event stream starts
+func (c *didValidityCache) resume(height uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = map[string]didValidity{}
+	c.live, c.warmed = true, false
+	c.startHeight = height
+	c.generation++
+}
+
+// suspend empties the cache when the stream stops
+func (c *didValidityCache) suspend() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = nil
+	c.live = false
+	c.generation++
+}
+
+// current returns the generation a read from the peers should be filled into
+func (c *didValidityCache) current() uint64 {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generation
+}
+
+// verify answers from the cache, reporting false when the DID must be read from the peers
+func (c *didValidityCache) verify(id string, now time.Time) (DIDVerification, bool) {
+	if c == nil {
+		return DIDVerification{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state, ok := c.entries[id]
+	if !c.live || !ok || now.Sub(state.loadedAt) >= c.maxAge {
+		return DIDVerification{}, false
+	}
+	verdict := state.verdict(id, now)
+	verdict.Source = "cache"
+	verdict.CachedAt = state.loadedAt.UTC().Format(time.RFC3339)
+	verdict.MaxStalenessSeconds = int(c.maxAge.Seconds())
+	return verdict, true
+}
+
+// fill caches a state read from the peers, unless the stream restarted or an event changed the
+// DID during the read
+func (c *didValidityCache) fill(generation uint64, id string, state didValidity, now time.Time) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.live || generation != c.generation {
+		return
+	}
+	if current, ok := c.entries[id]; ok && now.Sub(current.loadedAt) < c.maxAge {
+		return
+	}
+	state.block, state.loadedAt = c.startHeight, now
+	c.put(id, state)
+}
+
+// apply records a DID's state from an event. A stream resuming from a checkpoint replays events
+// from before it started, which only replace states older than them.
+func (c *didValidityCache) apply(id string, state didValidity, block uint64, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.live || id == "" {
+		return
+	}
+	current, ok := c.entries[id]
+	if (ok && current.block > block) || (!ok && block < c.startHeight) {
+		return
+	}
+	state.block, state.loadedAt = block, now
+	c.put(id, state)
+}
+
+// put stores a state, evicting an arbitrary one when the cache is full; callers hold c.mu
+func (c *didValidityCache) put(id string, state didValidity) {
+	if _, ok := c.entries[id]; !ok && len(c.entries) >= c.size {
+		for evicted := range c.entries {
+			delete(c.entries, evicted)
+			break
+		}
+	}
+	c.entries[id] = state
+}
+
+// warm loads the unexpired and revoked DIDs from the off-chain index once it has caught up with
+// the stream, so the checkpoints' traffic after a restart does not all go to the peers
+func (c *didValidityCache) warm(ctx context.Context, ix *offchainIndex) error {
+	c.mu.Lock()
+	generation, startHeight, pending := c.generation, c.startHeight, c.live && !c.warmed
+	c.mu.Unlock()
+	if !pending {
+		return nil
+	}
+	indexed, running, err := ix.indexedTo(ctx)
+	if err != nil {
+		return err
+	}
+	if !running || indexed+1 < startHeight {
+		return nil
+	}
+
+	rows, err := ix.db.Query(ctx, fmt.Sprintf(`SELECT digital_id, issuer, %s, %s, tx_id, block_number, (deleted_at IS NOT NULL)::int
+		FROM dids WHERE deleted_at IS NOT NULL OR expires_at > now() ORDER BY block_number DESC LIMIT $1`,
+		utcColumn("issued_at"), utcColumn("expires_at")), c.size)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if generation != c.generation {
+		return nil
+	}
+	warmed := 0
+	for _, row := range rows {
+		if len(c.entries) >= c.size {
+			break
+		}
+		id := row.String(0)
+		if _, ok := c.entries[id]; ok {
+			continue
+		}
+		state := didValidity{exists: true, issuer: row.String(1), issuedAt: row.String(2), expiresAt: row.String(3)}
+		if row.Int(6) == 1 {
+			state = didValidity{revoked: true}
+		}
+		state.txID, state.block, state.loadedAt = row.String(4), uint64(row.Int(5)), now
+		c.entries[id] = state
+		warmed++
+	}
+	c.warmed = true
+	log.Printf("🔖 Warmed the DID validity cache with %d states from the off-chain index", warmed)
+	return nil
+}
+
+// run warms the cache whenever the stream restarts, until ctx is done
+func (c *didValidityCache) run(ctx context.Context, ix *offchainIndex) {
+	ticker := time.NewTicker(didValidityWarmInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.warm(ctx, ix); err != nil {
+				log.Printf("🔖 Failed to warm the DID validity cache: %v", err)
+			}
+		}
+	}
+}
+
+// didValidityFromEvent applies the default target's DID events. Deleting a DID revokes it.
+func didValidityFromEvent(ctx context.Context, event *client.ChaincodeEvent) {
+	if didValidities == nil || !isDefaultTarget(ctx) {
+		return
+	}
+	now := time.Now()
+	switch event.EventName {
+	case "CreateDID", "UpdateDID":
+		did, err := decodeDocument[DIDDocument](event.Payload, "DID event")
+		if err != nil {
+			return
+		}
+		didValidities.apply(did.DigitalID, didValidityFromDocument(did, event), event.BlockNumber, now)
+	case "BatchIssueDID":
+		dids, err := decodeDocument[[]DIDDocument](event.Payload, "DID batch event")
+		if err != nil {
+			return
+		}
+		for _, did := range dids {
+			didValidities.apply(did.DigitalID, didValidityFromDocument(did, event), event.BlockNumber, now)
+		}
+	case "DeleteDID":
+		did, err := decodeDocument[DIDDocument](event.Payload, "DID event")
+		if err != nil {
+			return
+		}
+		didValidities.apply(did.DigitalID, didValidity{revoked: true, txID: event.TransactionID}, event.BlockNumber, now)
+	}
+}
+
+func didValidityFromDocument(did DIDDocument, event *client.ChaincodeEvent) didValidity {
+	state := didValidityOf(did)
+	state.txID = cmpOr(state.txID, event.TransactionID)
+	return state
+}
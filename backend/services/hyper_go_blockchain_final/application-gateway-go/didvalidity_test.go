@@ -0,0 +1,75 @@
+package gateway
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDIDValidityCache(t *testing.T) {
+	now := time.Date(2025, 9, 20, 8, 0, 0, 0, time.UTC)
+	c := &didValidityCache{maxAge: 10 * time.Minute, size: 2}
+	valid := didValidity{exists: true, issuer: "Org1MSP", issuedAt: "2025-09-18T00:00:00Z", expiresAt: "2025-09-20T08:15:00Z", txID: "tx1"}
+
+	// Nothing is cached while no event stream runs
+	c.fill(c.current(), "did:sih:a", valid, now)
+	if _, ok := c.verify("did:sih:a", now); ok {
+		t.Fatal("expected no answer before the stream starts")
+	}
+
+	c.resume(100)
+	c.fill(c.current(), "did:sih:a", valid, now)
+	verdict, ok := c.verify("did:sih:a", now.Add(time.Minute))
+	if !ok || !verdict.Valid || verdict.Source != "cache" || verdict.CachedAt != "2025-09-20T08:00:00Z" || verdict.MaxStalenessSeconds != 600 {
+		t.Fatalf("expected a cached valid verdict, got %+v", verdict)
+	}
+	if _, ok := c.verify("did:sih:a", now.Add(10*time.Minute)); ok {
+		t.Error("expected no answer once past the maximum age")
+	}
+	// Expiry is checked at verification time, not when the state was cached
+	c.apply("did:sih:a", valid, 101, now.Add(9*time.Minute))
+	if verdict, ok := c.verify("did:sih:a", now.Add(18*time.Minute)); !ok || verdict.Valid || !verdict.Expired {
+		t.Errorf("expected the DID expired by now, got %+v", verdict)
+	}
+
+	// A replayed event older than the state read from the peers is ignored; a new one applies
+	c.apply("did:sih:a", didValidity{revoked: true, txID: "tx0"}, 99, now)
+	if verdict, _ := c.verify("did:sih:a", now.Add(10*time.Minute)); verdict.Revoked {
+		t.Error("expected the replayed revocation ignored")
+	}
+	c.apply("did:sih:a", didValidity{revoked: true, txID: "tx2"}, 102, now.Add(10*time.Minute))
+	if verdict, _ := c.verify("did:sih:a", now.Add(10*time.Minute)); !verdict.Revoked || verdict.TxID != "tx2" || verdict.Valid {
+		t.Errorf("expected the DID revoked, got %+v", verdict)
+	}
+	c.apply("did:sih:b", valid, 99, now)
+	if _, ok := c.verify("did:sih:b", now); ok {
+		t.Error("expected a replayed event not to cache an unknown DID")
+	}
+
+	// A read that began before an event or a restart does not overwrite what came after
+	generation := c.current()
+	c.apply("did:sih:b", valid, 103, now)
+	c.fill(generation, "did:sih:b", didValidity{}, now)
+	if verdict, _ := c.verify("did:sih:b", now); !verdict.Exists {
+		t.Error("expected the event's state kept over the racing read")
+	}
+	c.suspend()
+	if _, ok := c.verify("did:sih:b", now); ok {
+		t.Error("expected no answer once the stream stops")
+	}
+	c.resume(110)
+	c.fill(generation, "did:sih:b", valid, now)
+	if _, ok := c.verify("did:sih:b", now); ok {
+		t.Error("expected a read from the previous stream dropped")
+	}
+
+	// A full cache makes room
+	for _, id := range []string{"did:sih:a", "did:sih:b", "did:sih:c"} {
+		c.fill(c.current(), id, valid, now)
+	}
+	if len(c.entries) != 2 {
+		t.Errorf("expected the cache capped at 2 states, got %d", len(c.entries))
+	}
+	if _, ok := c.verify("did:sih:c", now); !ok {
+		t.Error("expected the latest state cached")
+	}
+}
@@ -0,0 +1,443 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/identity"
+	"github.com/hyperledger/fabric-protos-go-apiv2/discovery"
+	"github.com/hyperledger/fabric-protos-go-apiv2/gossip"
+	"github.com/hyperledger/fabric-protos-go-apiv2/msp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	discoveryScheme  = "fabric-discovery"
+	discoveryTimeout = 10 * time.Second
+)
+
+// DiscoveredPeer is a peer that has joined a channel, as gossip reports it
+type DiscoveredPeer struct {
+	Endpoint     string   `json:"endpoint"`
+	MSPID        string   `json:"msp_id"`
+	LedgerHeight uint64   `json:"ledger_height"`
+	Chaincodes   []string `json:"chaincodes,omitempty"`
+}
+
+// DiscoveredOrderer is an ordering service endpoint from the channel configuration
+type DiscoveredOrderer struct {
+	Endpoint string `json:"endpoint"`
+	MSPID    string `json:"msp_id"`
+}
+
+// ChannelTopology lists the peers and orderers serving a channel
+type ChannelTopology struct {
+	Channel  string              `json:"channel"`
+	Peers    []DiscoveredPeer    `json:"peers"`
+	Orderers []DiscoveredOrderer `json:"orderers"`
+}
+
+// GatewayEndpoint is a peer the gateway connection can use, in the order it is tried
+type GatewayEndpoint struct {
+	Address    string `json:"address"`
+	ServerName string `json:"server_name"`
+	MSPID      string `json:"msp_id,omitempty"`
+	Local      bool   `json:"local"`
+	// Region is the peer's region from FABRIC_PEERS
+	Region string `json:"region,omitempty"`
+}
+
+// NetworkTopology is what the gateway last learned about the network
+type NetworkTopology struct {
+	Discovery   bool              `json:"discovery"`
+	Connection  string            `json:"connection"`
+	RefreshedAt string            `json:"refreshed_at,omitempty"`
+	Error       string            `json:"error,omitempty"`
+	Endpoints   []GatewayEndpoint `json:"endpoints"`
+	Channels    []ChannelTopology `json:"channels,omitempty"`
+	// Region is GATEWAY_REGION; FailedOver is set while no peer there answers
+	Region     string          `json:"region,omitempty"`
+	FailedOver bool            `json:"failed_over"`
+	Failovers  int             `json:"failovers"`
+	Events     EventLeadership `json:"events"`
+}
+
+// peerDiscovery keeps the gateway connection pointed at live peers. The connection resolves to
+// every known peer, local organization first, and gRPC fails over down that list when the peer in
+// use goes away. Discovery queries travel over the same connection, so they fail over too.
+type peerDiscovery struct {
+	router      *peerRouter
+	bootstrap   GatewayEndpoint
+	bootstrapCA *x509.Certificate
+	// roots holds the TLS CAs of every organization seen in a channel configuration
+	roots       atomic.Pointer[x509.CertPool]
+	asLocalhost bool
+	interval    time.Duration
+
+	id   identity.Identity
+	sign identity.Sign
+
+	mu          sync.RWMutex
+	channels    []ChannelTopology
+	endpoints   []GatewayEndpoint
+	refreshedAt time.Time
+	lastErr     error
+}
+
+var peerDiscoverer *peerDiscovery
+
+// newDiscoveryConnection dials the bootstrap peer through a resolver that later discovery rounds
+// fill with the rest of the network
+func newDiscoveryConnection(tlsCA *x509.Certificate) *grpc.ClientConn {
+	d := &peerDiscovery{
+		asLocalhost: getEnvBool("FABRIC_DISCOVERY_AS_LOCALHOST", false),
+		interval:    getEnvDuration("FABRIC_DISCOVERY_INTERVAL", 30*time.Second),
+	}
+	d.bootstrap, d.bootstrapCA = bootstrapEndpoint(), tlsCA
+	roots := x509.NewCertPool()
+	roots.AddCert(tlsCA)
+	d.roots.Store(roots)
+	// Until the first round, the connection tries the FABRIC_PEERS list, if any, then the bootstrap peer
+	d.endpoints = regions.endpoints(d.asLocalhost, d.bootstrap)
+	d.router = newPeerRouter(discoveryScheme, d.endpoints)
+	peerRouting = d.router
+
+	connection, err := grpc.NewClient(discoveryScheme+":///gateway",
+		grpc.WithResolvers(d.router.resolver),
+		grpc.WithTransportCredentials(d.credentials()),
+		grpc.WithDefaultServiceConfig(`{"loadBalancingConfig":[{"pick_first":{}}]}`),
+	)
+	if err != nil {
+		panic(fmt.Errorf("failed to create gRPC connection: %w", err))
+	}
+	peerDiscoverer = d
+	log.Printf("🧭 Peer discovery enabled, bootstrapping from %s", d.bootstrap.Address)
+	return connection
+}
+
+// credentials verifies each peer against the current TLS roots. Go's TLS only takes a fixed pool,
+// so verification is done here rather than by the handshake.
+func (d *peerDiscovery) credentials() credentials.TransportCredentials {
+	return credentials.NewTLS(&tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: true,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return errors.New("peer sent no certificate")
+			}
+			intermediates := x509.NewCertPool()
+			for _, cert := range cs.PeerCertificates[1:] {
+				intermediates.AddCert(cert)
+			}
+			_, err := cs.PeerCertificates[0].Verify(x509.VerifyOptions{
+				DNSName:       cs.ServerName,
+				Roots:         d.roots.Load(),
+				Intermediates: intermediates,
+			})
+			return err
+		},
+	})
+}
+
+// start runs the first discovery round with the gateway identity. A failure is logged, not fatal:
+// the connection keeps using the bootstrap peer and the next round tries again.
+func (d *peerDiscovery) start(id identity.Identity, sign identity.Sign) {
+	d.id, d.sign = id, sign
+	ctx, cancel := context.WithTimeout(context.Background(), discoveryTimeout)
+	defer cancel()
+	if err := d.refresh(ctx, clientConnection); err != nil {
+		log.Printf("⚠️  Peer discovery failed, using the bootstrap peer: %v", err)
+	}
+}
+
+// run refreshes the topology every FABRIC_DISCOVERY_INTERVAL until ctx is done
+func (d *peerDiscovery) run(ctx context.Context) {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			refreshCtx, cancel := context.WithTimeout(ctx, discoveryTimeout)
+			if err := d.refresh(refreshCtx, clientConnection); err != nil {
+				log.Printf("⚠️  Peer discovery failed, keeping %d known peers: %v", len(d.snapshot().Endpoints), err)
+			}
+			cancel()
+		}
+	}
+}
+
+// refresh asks the connected peer for the configuration and members of every target channel and
+// repoints the connection at the peers found
+func (d *peerDiscovery) refresh(ctx context.Context, conn grpc.ClientConnInterface) error {
+	topology, roots, err := d.discover(ctx, conn)
+	if err != nil {
+		d.mu.Lock()
+		d.lastErr = err
+		d.mu.Unlock()
+		return err
+	}
+
+	endpoints := gatewayEndpoints(topology, d.id.MspID(), regions, d.asLocalhost, d.bootstrap)
+	// Trust the new roots before the connection can reach peers that need them
+	d.roots.Store(roots)
+	d.router.update(endpoints)
+
+	d.mu.Lock()
+	d.channels, d.endpoints, d.refreshedAt, d.lastErr = topology, endpoints, time.Now(), nil
+	d.mu.Unlock()
+	return nil
+}
+
+// discover runs one discovery request and returns each channel's topology and the TLS roots of
+// every organization in them
+func (d *peerDiscovery) discover(ctx context.Context, conn grpc.ClientConnInterface) ([]ChannelTopology, *x509.CertPool, error) {
+	channels := targetChannels()
+	request, err := d.signedRequest(channels)
+	if err != nil {
+		return nil, nil, err
+	}
+	response, err := discovery.NewDiscoveryClient(conn).Discover(ctx, request)
+	if err != nil {
+		return nil, nil, fmt.Errorf("discovery request failed: %w", err)
+	}
+	results := response.GetResults()
+	if len(results) != 2*len(channels) {
+		return nil, nil, fmt.Errorf("discovery returned %d results for %d queries", len(results), 2*len(channels))
+	}
+
+	topology := make([]ChannelTopology, 0, len(channels))
+	roots := x509.NewCertPool()
+	roots.AddCert(d.bootstrapCA)
+	for i, channel := range channels {
+		config, members := results[2*i], results[2*i+1]
+		if e := config.GetError(); e != nil {
+			return nil, nil, fmt.Errorf("channel %s config: %s", channel, e.GetContent())
+		}
+		if e := members.GetError(); e != nil {
+			return nil, nil, fmt.Errorf("channel %s peers: %s", channel, e.GetContent())
+		}
+		topology = append(topology, channelTopology(channel, config.GetConfigResult(), members.GetMembers()))
+		addTLSRoots(roots, config.GetConfigResult())
+	}
+	return topology, roots, nil
+}
+
+// signedRequest asks for the configuration and peers of each channel, signed as the gateway
+func (d *peerDiscovery) signedRequest(channels []string) (*discovery.SignedRequest, error) {
+	creator, err := proto.Marshal(&msp.SerializedIdentity{Mspid: d.id.MspID(), IdBytes: d.id.Credentials()})
+	if err != nil {
+		return nil, err
+	}
+	request := &discovery.Request{Authentication: &discovery.AuthInfo{ClientIdentity: creator}}
+	for _, channel := range channels {
+		request.Queries = append(request.Queries,
+			&discovery.Query{Channel: channel, Query: &discovery.Query_ConfigQuery{ConfigQuery: &discovery.ConfigQuery{}}},
+			&discovery.Query{Channel: channel, Query: &discovery.Query_PeerQuery{PeerQuery: &discovery.PeerMembershipQuery{}}},
+		)
+	}
+	payload, err := proto.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256(payload)
+	signature, err := d.sign(digest[:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign discovery request: %w", err)
+	}
+	return &discovery.SignedRequest{Payload: payload, Signature: signature}, nil
+}
+
+// targetChannels lists the channels of the registered targets, once each
+func targetChannels() []string {
+	var channels []string
+	for _, target := range sortedTargets() {
+		if !slices.Contains(channels, target.Channel) {
+			channels = append(channels, target.Channel)
+		}
+	}
+	return channels
+}
+
+// channelTopology reads the peers from their gossip messages and the orderers from the config.
+// Peers that have left the channel are skipped.
+func channelTopology(channel string, config *discovery.ConfigResult, members *discovery.PeerMembershipResult) ChannelTopology {
+	topology := ChannelTopology{Channel: channel, Peers: []DiscoveredPeer{}, Orderers: []DiscoveredOrderer{}}
+	for org, peers := range members.GetPeersByOrg() {
+		for _, p := range peers.GetPeers() {
+			var alive, state gossip.GossipMessage
+			if proto.Unmarshal(p.GetMembershipInfo().GetPayload(), &alive) != nil {
+				continue
+			}
+			endpoint := alive.GetAliveMsg().GetMembership().GetEndpoint()
+			if endpoint == "" {
+				continue
+			}
+			peer := DiscoveredPeer{Endpoint: endpoint, MSPID: org}
+			if proto.Unmarshal(p.GetStateInfo().GetPayload(), &state) == nil {
+				properties := state.GetStateInfo().GetProperties()
+				if properties.GetLeftChannel() {
+					continue
+				}
+				peer.LedgerHeight = properties.GetLedgerHeight()
+				for _, cc := range properties.GetChaincodes() {
+					peer.Chaincodes = append(peer.Chaincodes, cc.GetName())
+				}
+			}
+			topology.Peers = append(topology.Peers, peer)
+		}
+	}
+	for org, endpoints := range config.GetOrderers() {
+		for _, e := range endpoints.GetEndpoint() {
+			topology.Orderers = append(topology.Orderers, DiscoveredOrderer{
+				Endpoint: net.JoinHostPort(e.GetHost(), strconv.Itoa(int(e.GetPort()))),
+				MSPID:    org,
+			})
+		}
+	}
+	sort.Slice(topology.Peers, func(i, j int) bool { return topology.Peers[i].Endpoint < topology.Peers[j].Endpoint })
+	sort.Slice(topology.Orderers, func(i, j int) bool { return topology.Orderers[i].Endpoint < topology.Orderers[j].Endpoint })
+	return topology
+}
+
+// addTLSRoots trusts the TLS CAs of every organization in the channel configuration
+func addTLSRoots(roots *x509.CertPool, config *discovery.ConfigResult) {
+	for _, org := range config.GetMsps() {
+		for _, pem := range append(org.GetTlsRootCerts(), org.GetTlsIntermediateCerts()...) {
+			roots.AppendCertsFromPEM(pem)
+		}
+	}
+}
+
+// gatewayEndpoints orders the discovered peers for the connection to try: peers on every target
+// channel before the rest, those in the gateway's region before remote ones, the local
+// organization's before others', then the most up to date. The bootstrap peer stays on the list as
+// a last resort.
+func gatewayEndpoints(channels []ChannelTopology, localMSP string, regions peerRegions, asLocalhost bool, bootstrap GatewayEndpoint) []GatewayEndpoint {
+	type candidate struct {
+		peer     DiscoveredPeer
+		channels int
+	}
+	byEndpoint := map[string]*candidate{}
+	for _, channel := range channels {
+		for _, peer := range channel.Peers {
+			c, ok := byEndpoint[peer.Endpoint]
+			if !ok {
+				c = &candidate{peer: peer}
+				byEndpoint[peer.Endpoint] = c
+			}
+			c.channels++
+			c.peer.LedgerHeight = max(c.peer.LedgerHeight, peer.LedgerHeight)
+		}
+	}
+	candidates := make([]*candidate, 0, len(byEndpoint))
+	for _, c := range byEndpoint {
+		candidates = append(candidates, c)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.channels != b.channels {
+			return a.channels > b.channels
+		}
+		if regions.preferred(a.peer.Endpoint) != regions.preferred(b.peer.Endpoint) {
+			return regions.preferred(a.peer.Endpoint)
+		}
+		if (a.peer.MSPID == localMSP) != (b.peer.MSPID == localMSP) {
+			return a.peer.MSPID == localMSP
+		}
+		if a.peer.LedgerHeight != b.peer.LedgerHeight {
+			return a.peer.LedgerHeight > b.peer.LedgerHeight
+		}
+		return a.peer.Endpoint < b.peer.Endpoint
+	})
+
+	endpoints := make([]GatewayEndpoint, 0, len(candidates)+1)
+	for _, c := range candidates {
+		host, port, err := net.SplitHostPort(c.peer.Endpoint)
+		if err != nil {
+			continue
+		}
+		address := c.peer.Endpoint
+		if asLocalhost {
+			address = net.JoinHostPort("localhost", port)
+		}
+		endpoints = append(endpoints, GatewayEndpoint{Address: address, ServerName: host, MSPID: c.peer.MSPID, Local: c.peer.MSPID == localMSP, Region: regions.byEndpoint[c.peer.Endpoint]})
+	}
+	if !slices.ContainsFunc(endpoints, func(e GatewayEndpoint) bool { return e.Address == bootstrap.Address }) {
+		endpoints = append(endpoints, bootstrap)
+	}
+	return endpoints
+}
+
+// bootstrapEndpoint is the configured gateway peer
+func bootstrapEndpoint() GatewayEndpoint {
+	return GatewayEndpoint{Address: strings.TrimPrefix(peerEndpoint, "dns:///"), ServerName: gatewayPeer, MSPID: mspID, Local: true}
+}
+
+func resolverAddresses(endpoints []GatewayEndpoint) []resolver.Address {
+	addresses := make([]resolver.Address, len(endpoints))
+	for i, e := range endpoints {
+		addresses[i] = resolver.Address{Addr: e.Address, ServerName: e.ServerName}
+	}
+	return addresses
+}
+
+// snapshot reports the last discovery round
+func (d *peerDiscovery) snapshot() NetworkTopology {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	topology := NetworkTopology{Discovery: true, Endpoints: d.endpoints, Channels: d.channels}
+	if !d.refreshedAt.IsZero() {
+		topology.RefreshedAt = d.refreshedAt.UTC().Format(time.RFC3339)
+	}
+	if d.lastErr != nil {
+		topology.Error = d.lastErr.Error()
+	}
+	return topology
+}
+
+// getNetworkTopology shows the peers the gateway connection can fail over to, what discovery last
+// found on each channel, and which replica leads event processing
+func getNetworkTopology(c *gin.Context) {
+	topology := NetworkTopology{Endpoints: []GatewayEndpoint{bootstrapEndpoint()}}
+	switch {
+	case peerDiscoverer != nil:
+		topology = peerDiscoverer.snapshot()
+	case peerRouting != nil:
+		topology.Endpoints = peerRouting.snapshot()
+	}
+	if peerRouting != nil {
+		topology.FailedOver, topology.Failovers = peerRouting.status()
+	}
+	topology.Region = regions.local
+	topology.Events = eventLeadership.status()
+	if clientConnection != nil {
+		topology.Connection = clientConnection.GetState().String()
+	}
+	respondData(c, http.StatusOK, topology)
+}
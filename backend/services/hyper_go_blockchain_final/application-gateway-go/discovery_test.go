@@ -0,0 +1,128 @@
+package gateway
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-gateway/pkg/identity"
+	"github.com/hyperledger/fabric-protos-go-apiv2/discovery"
+	"github.com/hyperledger/fabric-protos-go-apiv2/gossip"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+)
+
+// discoveredPeer builds the gossip envelopes discovery returns for a peer
+func discoveredPeer(t *testing.T, endpoint string, height uint64, left bool) *discovery.Peer {
+	alive, err := proto.Marshal(&gossip.GossipMessage{Content: &gossip.GossipMessage_AliveMsg{
+		AliveMsg: &gossip.AliveMessage{Membership: &gossip.Member{Endpoint: endpoint}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, err := proto.Marshal(&gossip.GossipMessage{Content: &gossip.GossipMessage_StateInfo{
+		StateInfo: &gossip.StateInfo{Properties: &gossip.Properties{LedgerHeight: height, LeftChannel: left, Chaincodes: []*gossip.Chaincode{{Name: "sihcc"}}}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &discovery.Peer{MembershipInfo: &gossip.Envelope{Payload: alive}, StateInfo: &gossip.Envelope{Payload: state}}
+}
+
+// fakeDiscoveryConn answers Discover with a canned response
+type fakeDiscoveryConn struct {
+	grpc.ClientConnInterface
+	response *discovery.Response
+	request  *discovery.SignedRequest
+}
+
+func (f *fakeDiscoveryConn) Invoke(_ context.Context, method string, args, reply interface{}, _ ...grpc.CallOption) error {
+	f.request = args.(*discovery.SignedRequest)
+	proto.Merge(reply.(*discovery.Response), f.response)
+	return nil
+}
+
+func TestPeerDiscovery(t *testing.T) {
+	members := &discovery.PeerMembershipResult{PeersByOrg: map[string]*discovery.Peers{
+		"Org1MSP": {Peers: []*discovery.Peer{
+			discoveredPeer(t, "peer1.org1.example.com:8051", 40, false),
+			discoveredPeer(t, "peer0.org1.example.com:7051", 42, false),
+			discoveredPeer(t, "peer2.org1.example.com:10051", 42, true),
+		}},
+		"Org2MSP": {Peers: []*discovery.Peer{discoveredPeer(t, "peer0.org2.example.com:9051", 42, false)}},
+	}}
+	config := &discovery.ConfigResult{Orderers: map[string]*discovery.Endpoints{
+		"OrdererMSP": {Endpoint: []*discovery.Endpoint{{Host: "orderer.example.com", Port: 7050}}},
+	}}
+
+	topology := channelTopology("mychannel", config, members)
+	if len(topology.Peers) != 3 || len(topology.Orderers) != 1 || topology.Orderers[0].Endpoint != "orderer.example.com:7050" {
+		t.Fatalf("expected three peers that are still on the channel and one orderer, got %+v", topology)
+	}
+
+	bootstrap := GatewayEndpoint{Address: "localhost:7051", ServerName: "peer0.org1.example.com", MSPID: "Org1MSP", Local: true}
+	endpoints := gatewayEndpoints([]ChannelTopology{topology}, "Org1MSP", peerRegions{}, true, bootstrap)
+	order := []string{"peer0.org1.example.com", "peer1.org1.example.com", "peer0.org2.example.com"}
+	if len(endpoints) != 3 {
+		t.Fatalf("expected the bootstrap peer folded into the discovered one, got %+v", endpoints)
+	}
+	for i, name := range order {
+		if endpoints[i].ServerName != name {
+			t.Errorf("endpoint %d = %+v, expected %s", i, endpoints[i], name)
+		}
+	}
+	if endpoints[0].Address != "localhost:7051" || !endpoints[1].Local || endpoints[2].Local {
+		t.Errorf("expected localhost addresses and local peers marked, got %+v", endpoints)
+	}
+
+	// A peer on every target channel beats a local peer that only serves one
+	other := ChannelTopology{Channel: "permits", Peers: []DiscoveredPeer{{Endpoint: "peer0.org2.example.com:9051", MSPID: "Org2MSP"}}}
+	if endpoints := gatewayEndpoints([]ChannelTopology{topology, other}, "Org1MSP", peerRegions{}, false, bootstrap); endpoints[0].ServerName != "peer0.org2.example.com" || endpoints[len(endpoints)-1] != bootstrap {
+		t.Errorf("expected the peer on both channels first and the bootstrap peer last, got %+v", endpoints)
+	}
+
+	// One round over a fake connection, signed as the gateway identity
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "gateway"}, NotBefore: time.Now(), NotAfter: time.Now().Add(time.Hour)}
+	der, _ := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	cert, _ := x509.ParseCertificate(der)
+	id, err := identity.NewX509Identity("Org1MSP", cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previous := fabricTargets
+	fabricTargets = map[string]*fabricTarget{"main": {Name: "main", Channel: "mychannel", Chaincode: "sihcc"}}
+	defer func() { fabricTargets = previous }()
+	d := &peerDiscovery{router: newPeerRouter(discoveryScheme, nil), bootstrap: bootstrap, bootstrapCA: cert}
+	d.id, d.sign = id, func(digest []byte) ([]byte, error) { return []byte("signed"), nil }
+	conn := &fakeDiscoveryConn{response: &discovery.Response{Results: []*discovery.QueryResult{
+		{Result: &discovery.QueryResult_ConfigResult{ConfigResult: config}},
+		{Result: &discovery.QueryResult_Members{Members: members}},
+	}}}
+	if err := d.refresh(context.Background(), conn); err != nil {
+		t.Fatal(err)
+	}
+	var request discovery.Request
+	proto.Unmarshal(conn.request.Payload, &request)
+	if string(conn.request.Signature) != "signed" || len(request.Queries) != 2 || request.Queries[0].Channel != "mychannel" {
+		t.Errorf("unexpected discovery request %+v", &request)
+	}
+	if snapshot := d.snapshot(); len(snapshot.Endpoints) != 4 || snapshot.RefreshedAt == "" || snapshot.Error != "" {
+		t.Errorf("unexpected topology %+v", snapshot)
+	}
+
+	conn.response = &discovery.Response{Results: []*discovery.QueryResult{
+		{Result: &discovery.QueryResult_Error{Error: &discovery.Error{Content: "access denied"}}},
+		{Result: &discovery.QueryResult_Members{Members: members}},
+	}}
+	if err := d.refresh(context.Background(), conn); err == nil || len(d.snapshot().Endpoints) != 4 || d.snapshot().Error == "" {
+		t.Errorf("expected a failed round to keep the known peers and report the error, got %v", err)
+	}
+}
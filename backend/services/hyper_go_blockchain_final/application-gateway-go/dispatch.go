@@ -0,0 +1,832 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	errCodeDispatchDisabled = "DISPATCH_DISABLED"
+
+	dispatchCasePrefix   = "sih:dispatch:case:"
+	dispatchDeadlinesKey = "sih:dispatch:deadlines"
+	dispatchRosterKey    = "sih:dispatch:roster"
+	dispatchProfilesKey  = "sih:dispatch:profiles"
+	dispatchPositionsKey = "sih:dispatch:positions"
+	dispatchActor        = "dispatcher"
+
+	maxDeclineReasonLength = 200
+
+	unitPolice  = "police"
+	unitMedical = "medical"
+)
+
+var (
+	unitKinds    = []string{unitPolice, unitMedical}
+	unitStatuses = []string{"available", "busy", "off_duty"}
+)
+
+// AssignmentDocument is a responder unit sent to an SOS alert or incident, as the chaincode stores it
+type AssignmentDocument struct {
+	DocType        string  `json:"doc_type"`
+	AssignmentID   string  `json:"assignment_id"`
+	SubjectType    string  `json:"subject_type"`
+	SubjectID      string  `json:"subject_id"`
+	UnitID         string  `json:"unit_id"`
+	UnitKind       string  `json:"unit_kind"`
+	Attempt        int     `json:"attempt"`
+	DistanceKm     float64 `json:"distance_km"`
+	Status         string  `json:"status"`
+	AssignedAt     string  `json:"assigned_at"`
+	AckDeadline    string  `json:"ack_deadline"`
+	AcknowledgedAt string  `json:"acknowledged_at,omitempty"`
+	AcknowledgedBy string  `json:"acknowledged_by,omitempty"`
+	EscalatedAt    string  `json:"escalated_at,omitempty"`
+	EscalatedTo    string  `json:"escalated_to,omitempty"`
+	DeclinedAt     string  `json:"declined_at,omitempty"`
+	DeclineReason  string  `json:"decline_reason,omitempty"`
+	OwnerOrg       string  `json:"owner_org,omitempty"`
+	TxID           string  `json:"tx_id"`
+}
+
+// AcknowledgeAssignmentRequest is a unit accepting an assignment
+type AcknowledgeAssignmentRequest struct {
+	UnitID string `json:"unitID" binding:"required"`
+}
+
+func (r AcknowledgeAssignmentRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.identifier("unitID", r.UnitID)
+	return v.errors
+}
+
+// DeclineAssignmentRequest is a unit refusing an assignment, which passes it to the next unit at once
+type DeclineAssignmentRequest struct {
+	UnitID string `json:"unitID" binding:"required"`
+	Reason string `json:"reason"`
+}
+
+func (r DeclineAssignmentRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.identifier("unitID", r.UnitID)
+	if len(r.Reason) > maxDeclineReasonLength {
+		v.add("reason", "must be at most %d characters", maxDeclineReasonLength)
+	}
+	return v.errors
+}
+
+// AssignmentListRequest names the SOS alert or incident whose assignments to list
+type AssignmentListRequest struct {
+	Subject string `form:"subject" binding:"required"`
+}
+
+func (r AssignmentListRequest) Validate() ValidationErrors {
+	return validateDocumentID("subject", r.Subject)
+}
+
+// UnitStatusRequest changes a unit's place on the availability roster
+type UnitStatusRequest struct {
+	Status string `json:"status" binding:"required"`
+}
+
+func (r UnitStatusRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.oneOf("status", r.Status, unitStatuses)
+	return v.errors
+}
+
+// DispatchUnit is a unit from POLICE_UNITS_FILE with its roster status, which is off_shift for an
+// available unit outside its shifts. Position is only set while the unit's last report is fresh.
+type DispatchUnit struct {
+	PoliceUnit
+	Kind     string        `json:"kind"`
+	Status   string        `json:"status"`
+	OnShift  bool          `json:"on_shift"`
+	Position *UnitPosition `json:"position,omitempty"`
+}
+
+// dispatchCase is what the dispatcher needs to escalate an assignment: the subject, where it is, the
+// units already tried and the alert to send the next unit
+type dispatchCase struct {
+	AssignmentID string          `json:"assignment_id"`
+	SubjectType  string          `json:"subject_type"`
+	SubjectID    string          `json:"subject_id"`
+	UnitKind     string          `json:"unit_kind"`
+	Capabilities []string        `json:"capabilities,omitempty"`
+	Attempt      int             `json:"attempt"`
+	Tried        []string        `json:"tried"`
+	Alert        SOSNotification `json:"alert"`
+}
+
+// dispatchStore keeps open cases, their acknowledgement deadlines and the unit roster: statuses,
+// profiles and last positions
+type dispatchStore interface {
+	loadCase(ctx context.Context, assignmentID string) (*dispatchCase, error)
+	// schedule saves the case and queues its assignment for escalation at deadline
+	schedule(ctx context.Context, c dispatchCase, deadline time.Time) error
+	// unschedule drops an acknowledged assignment from the queue
+	unschedule(ctx context.Context, assignmentID string) error
+	// due removes and returns the assignments whose deadline is before now
+	due(ctx context.Context, now time.Time) ([]string, error)
+	roster(ctx context.Context) (map[string]string, error)
+	setStatus(ctx context.Context, unitID, status string) error
+	profiles(ctx context.Context) (map[string]UnitProfile, error)
+	setProfile(ctx context.Context, profile UnitProfile) error
+	positions(ctx context.Context) (map[string]UnitPosition, error)
+	setPosition(ctx context.Context, unitID string, position UnitPosition) error
+	// appendTrack adds fixes to a unit's track, dropping those recorded before cutoff
+	appendTrack(ctx context.Context, unitID string, points []UnitPosition, cutoff time.Time) error
+	// track returns up to limit of a unit's fixes recorded between from and to, oldest first
+	track(ctx context.Context, unitID string, from, to time.Time, limit int) ([]UnitPosition, error)
+}
+
+// dispatchService assigns the nearest available unit to SOS alerts and critical incidents and
+// escalates assignments nobody acknowledges
+type dispatchService struct {
+	store          dispatchStore
+	ackTimeout     time.Duration
+	maxAttempts    int
+	maxKm          float64
+	controlRoomURL string
+	rosterLocation *time.Location
+	positionMaxAge time.Duration
+	// trackRetention is how long unit tracks are kept for after-action review
+	trackRetention time.Duration
+	// required maps category/kind to the capabilities an incident's unit of that kind needs
+	required map[string][]string
+}
+
+var dispatcher *dispatchService
+
+// initDispatch runs after initSOS, initDocumentCache and initPush. DISPATCH_REQUIRED_CAPABILITIES
+// is a list of category/kind=capability pairs, such as missing_person/police=search_rescue.
+func initDispatch() {
+	if !getEnvBool("DISPATCH_ENABLED", false) {
+		log.Println("🚓 DISPATCH_ENABLED not set, responder dispatch disabled")
+		return
+	}
+	ttl := getEnvDuration("DISPATCH_CASE_TTL", 24*time.Hour)
+	var store dispatchStore = newMemoryDispatchStore()
+	backend := "memory"
+	if documentCache != nil {
+		store, backend = redisDispatchStore{documentCache, ttl}, "Redis"
+	}
+	dispatcher = &dispatchService{
+		store:          store,
+		ackTimeout:     getEnvDuration("DISPATCH_ACK_TIMEOUT", 2*time.Minute),
+		maxAttempts:    getEnvInt("DISPATCH_MAX_ATTEMPTS", 3),
+		maxKm:          float64(getEnvInt("SOS_MAX_UNIT_DISTANCE_KM", 50)),
+		controlRoomURL: getEnv("DISPATCH_CONTROL_ROOM_URL", ""),
+	}
+	if dispatcher.ackTimeout <= 0 || dispatcher.maxAttempts < 1 {
+		panic(fmt.Errorf("DISPATCH_ACK_TIMEOUT must be positive and DISPATCH_MAX_ATTEMPTS at least 1"))
+	}
+	timezone := getEnv("ROSTER_TIMEZONE", defaultZoneTimezone)
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		panic(fmt.Errorf("ROSTER_TIMEZONE %q is not a known timezone", timezone))
+	}
+	dispatcher.rosterLocation = location
+	dispatcher.positionMaxAge = getEnvDuration("ROSTER_POSITION_MAX_AGE", 10*time.Minute)
+	if dispatcher.trackRetention = getEnvDuration("UNIT_TRACK_RETENTION", 30*24*time.Hour); dispatcher.trackRetention <= 0 {
+		panic(fmt.Errorf("UNIT_TRACK_RETENTION must be positive"))
+	}
+	if dispatcher.required, err = parseRequiredCapabilities(getEnv("DISPATCH_REQUIRED_CAPABILITIES", "")); err != nil {
+		panic(fmt.Errorf("DISPATCH_REQUIRED_CAPABILITIES %w", err))
+	}
+	log.Printf("🚓 Dispatching %d units with rosters in %s; escalating after %s, up to %d attempts",
+		len(policeUnits), backend, dispatcher.ackTimeout, dispatcher.maxAttempts)
+}
+
+// assignmentID is deterministic so a retried dispatch cannot assign the same attempt twice
+func assignmentID(subjectID, kind string, attempt int) string {
+	return fmt.Sprintf("ASG:%s:%s:%d", subjectID, kind, attempt)
+}
+
+// assign records an assignment on the ledger and starts its acknowledgement clock
+func (d *dispatchService) assign(ctx context.Context, c dispatchCase, unit *PoliceUnit, distance float64) error {
+	deadline := time.Now().Add(d.ackTimeout).UTC()
+	_, err := submitTransaction(ctx, "CreateAssignment", c.AssignmentID, c.SubjectType, c.SubjectID, unit.ID, c.UnitKind,
+		strconv.Itoa(c.Attempt), strconv.FormatFloat(math.Round(distance*100)/100, 'f', 2, 64), deadline.Format(time.RFC3339), dispatchActor)
+	if err != nil && translateFabricError(err).Code != errCodeAlreadyExists {
+		return err
+	}
+	return d.store.schedule(ctx, c, deadline)
+}
+
+// openSOS records the assignment for a unit RaiseSOS already notified
+func (d *dispatchService) openSOS(ctx context.Context, alert SOSNotification, unit *PoliceUnit, distance float64) {
+	c := dispatchCase{
+		AssignmentID: alert.AssignmentID, SubjectType: "sos", SubjectID: alert.AlertID, UnitKind: unitPolice,
+		Attempt: 1, Tried: []string{unit.ID}, Alert: alert,
+	}
+	if err := d.assign(ctx, c, unit, distance); err != nil {
+		log.Printf("🚓 Failed to record assignment %s: %v", c.AssignmentID, err)
+	}
+}
+
+// dispatchIncident sends police to a critical incident, and a medical unit as well when someone may
+// be hurt
+func (d *dispatchService) dispatchIncident(ctx context.Context, req CreateIncidentRequest, txID string) {
+	kinds := []string{unitPolice}
+	if req.Category == "medical" || req.Category == "accident" {
+		kinds = append(kinds, unitMedical)
+	}
+	lat, lng := *req.Latitude, *req.Longitude
+	alert := SOSNotification{
+		AlertID:   req.IncidentID,
+		DigitalID: req.DigitalID,
+		Latitude:  lat,
+		Longitude: lng,
+		Message:   fmt.Sprintf("Critical %s incident", req.Category),
+		Source:    "incident",
+		RaisedAt:  time.Now().UTC().Format(time.RFC3339),
+		MapURL:    fmt.Sprintf("https://maps.google.com/?q=%.6f,%.6f", lat, lng),
+		TxID:      txID,
+	}
+	for _, kind := range kinds {
+		c := dispatchCase{SubjectType: "incident", SubjectID: req.IncidentID, UnitKind: kind, Capabilities: d.required[req.Category+"/"+kind], Alert: alert}
+		d.next(ctx, c, nil)
+	}
+}
+
+// next assigns the nearest untried unit as the case's next attempt and notifies it. A unit with the
+// case's capabilities is preferred, but any available unit is sent rather than none. The previous
+// assignment, if any, is closed on the ledger by close, pointing at its replacement; with no unit
+// left or no attempts left it is closed empty and the control room is alerted instead. Only the
+// error from close is returned, as the case cannot move on without it.
+func (d *dispatchService) next(ctx context.Context, c dispatchCase, close func(replacement string) error) error {
+	escalated := c.AssignmentID
+	if close == nil {
+		escalated = ""
+	}
+	c.Attempt++
+	var unit *PoliceUnit
+	var distance float64
+	if c.Attempt <= d.maxAttempts {
+		q := unitQuery{kind: c.UnitKind, lat: c.Alert.Latitude, lng: c.Alert.Longitude, capabilities: c.Capabilities, exclude: c.Tried}
+		var err error
+		unit, distance, err = d.nearestAvailable(ctx, q)
+		if err == nil && unit == nil && len(q.capabilities) > 0 {
+			q.capabilities = nil
+			unit, distance, err = d.nearestAvailable(ctx, q)
+		}
+		if err != nil {
+			log.Printf("🚓 Failed to read the unit roster for %s: %v", c.SubjectID, err)
+		}
+	}
+	c.AssignmentID = ""
+	if unit != nil {
+		c.AssignmentID = assignmentID(c.SubjectID, c.UnitKind, c.Attempt)
+	}
+
+	if close != nil {
+		if err := close(c.AssignmentID); err != nil {
+			return err
+		}
+	}
+	if unit == nil {
+		d.alertControlRoom(ctx, c, escalated)
+		return nil
+	}
+
+	c.Tried = append(c.Tried, unit.ID)
+	c.Alert.AssignmentID, c.Alert.PoliceUnit = c.AssignmentID, unit.Name
+	if err := d.assign(ctx, c, unit, distance); err != nil {
+		log.Printf("🚓 Failed to assign %s to %s: %v", unit.ID, c.SubjectID, err)
+		d.alertControlRoom(ctx, c, escalated)
+		return nil
+	}
+	log.Printf("🚓 Assigned %s unit %s to %s %s (attempt %d, %.2f km)", c.UnitKind, unit.ID, c.SubjectType, c.SubjectID, c.Attempt, distance)
+	sosNotifier.fanOut(ctx, c.Alert, unit, nil)
+	return nil
+}
+
+// alertControlRoom hands a case nobody could take to the control room, by push and by webhook
+func (d *dispatchService) alertControlRoom(ctx context.Context, c dispatchCase, escalated string) {
+	log.Printf("🚓 No %s unit took %s %s, alerting the control room", c.UnitKind, c.SubjectType, c.SubjectID)
+	if pusher != nil {
+		pusher.deliver(ctx, pushAlert{
+			key:   "dispatch:" + c.SubjectID + ":" + c.UnitKind,
+			title: "Unassigned emergency",
+			body:  fmt.Sprintf("No %s unit has taken %s %s", c.UnitKind, c.SubjectType, c.SubjectID),
+			data:  map[string]string{"type": "dispatch_escalation", "subject_type": c.SubjectType, "subject_id": c.SubjectID, "unit_kind": c.UnitKind, "assignment_id": escalated},
+		})
+	}
+	if d.controlRoomURL != "" {
+		alert := c.Alert
+		alert.AssignmentID, alert.PoliceUnit, alert.RecipientKind = escalated, "", "control_room"
+		if err := sosNotifier.post(ctx, d.controlRoomURL, alert); err != nil {
+			log.Printf("🚓 Failed to alert the control room about %s: %v", c.SubjectID, err)
+		}
+	}
+}
+
+// run escalates assignments past their deadline. Each is claimed by exactly one gateway instance.
+func (d *dispatchService) run(ctx context.Context) {
+	ticker := time.NewTicker(min(d.ackTimeout/4, 15*time.Second))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := d.sweep(context.Background(), time.Now()); err != nil {
+				log.Printf("🚓 Failed to sweep dispatch deadlines: %v", err)
+			}
+		}
+	}
+}
+
+func (d *dispatchService) sweep(ctx context.Context, now time.Time) error {
+	due, err := d.store.due(ctx, now)
+	if err != nil {
+		return err
+	}
+	for _, id := range due {
+		c, err := d.store.loadCase(ctx, id)
+		if err != nil {
+			return err
+		}
+		if c == nil {
+			continue
+		}
+		assignment, err := ledger.ReadAssignment(ctx, id)
+		if err != nil {
+			log.Printf("🚓 Failed to read assignment %s: %v", id, err)
+			continue
+		}
+		if assignment.Status != "assigned" {
+			continue
+		}
+		escalate := func(replacement string) error {
+			_, err := submitTransaction(ctx, "EscalateAssignment", id, replacement, dispatchActor)
+			return err
+		}
+		if err := d.next(ctx, *c, escalate); err != nil {
+			log.Printf("🚓 Failed to escalate assignment %s: %v", id, err)
+		}
+	}
+	return nil
+}
+
+// ReadAssignment returns one assignment from the ledger
+func (ledgerService) ReadAssignment(ctx context.Context, assignmentID string) (*AssignmentDocument, error) {
+	result, err := evaluateTransaction(ctx, "ReadAssignment", assignmentID)
+	if err != nil {
+		return nil, err
+	}
+	assignment, err := decodeDocument[AssignmentDocument](result, "assignment")
+	if err != nil {
+		return nil, err
+	}
+	return &assignment, nil
+}
+
+// ListAssignments returns every assignment for an SOS alert or incident, oldest first
+func (ledgerService) ListAssignments(ctx context.Context, req AssignmentListRequest) ([]AssignmentDocument, error) {
+	if errs := req.Validate(); len(errs) > 0 {
+		return nil, errs
+	}
+	result, err := evaluateTransaction(ctx, "GetAssignmentsBySubject", req.Subject)
+	if err != nil {
+		return nil, err
+	}
+	assignments, err := decodeDocument[[]AssignmentDocument](result, "assignment")
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(assignments, func(i, j int) bool {
+		if assignments[i].AssignedAt != assignments[j].AssignedAt {
+			return assignments[i].AssignedAt < assignments[j].AssignedAt
+		}
+		return assignments[i].AssignmentID < assignments[j].AssignmentID
+	})
+	return assignments, nil
+}
+
+// AcknowledgeAssignment records the unit's acceptance, stops the escalation clock and marks the unit
+// busy on the roster
+func (ledgerService) AcknowledgeAssignment(ctx context.Context, assignmentID string, req AcknowledgeAssignmentRequest) (*TransactionResult, error) {
+	if err := validateMutation(assignmentID, req); err != nil {
+		return nil, err
+	}
+	result, err := submitTransaction(ctx, "AcknowledgeAssignment", assignmentID, req.UnitID)
+	if err != nil {
+		return nil, err
+	}
+	if err := dispatcher.store.unschedule(ctx, assignmentID); err != nil {
+		logWithContext(ctx, "Failed to unschedule assignment %s: %v", assignmentID, err)
+	}
+	if err := dispatcher.store.setStatus(ctx, req.UnitID, "busy"); err != nil {
+		logWithContext(ctx, "Failed to mark unit %s busy: %v", req.UnitID, err)
+	}
+	return result, nil
+}
+
+// DeclineAssignment records the unit's refusal and passes the case to the next unit straight away,
+// instead of at the acknowledgement deadline. It returns the decline's transaction and the
+// replacement assignment, which is empty when the case went to the control room.
+func (ledgerService) DeclineAssignment(ctx context.Context, assignmentID string, req DeclineAssignmentRequest) (*TransactionResult, string, error) {
+	if err := validateMutation(assignmentID, req); err != nil {
+		return nil, "", err
+	}
+	c, err := dispatcher.store.loadCase(ctx, assignmentID)
+	if err != nil {
+		return nil, "", err
+	}
+	var result *TransactionResult
+	var replacement string
+	decline := func(next string) error {
+		var err error
+		result, err = submitTransaction(ctx, "DeclineAssignment", assignmentID, req.UnitID, req.Reason, next)
+		replacement = next
+		return err
+	}
+	if c == nil {
+		// The case has expired, so there is nothing to pass on
+		err = decline("")
+	} else {
+		err = dispatcher.next(ctx, *c, decline)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if err := dispatcher.store.unschedule(ctx, assignmentID); err != nil {
+		logWithContext(ctx, "Failed to unschedule assignment %s: %v", assignmentID, err)
+	}
+	return result, replacement, nil
+}
+
+// requireDispatch reports whether dispatch is enabled, responding 503 when it is not
+func requireDispatch(c *gin.Context) bool {
+	if dispatcher == nil {
+		respondError(c, http.StatusServiceUnavailable, errCodeDispatchDisabled, "Responder dispatch is not enabled")
+		return false
+	}
+	return true
+}
+
+func acknowledgeAssignment(c *gin.Context) {
+	if !requireDispatch(c) {
+		return
+	}
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	var req AcknowledgeAssignmentRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+	setAuditTarget(c, id)
+
+	result, err := ledger.AcknowledgeAssignment(c.Request.Context(), id, req)
+	if err != nil {
+		respondServiceError(c, "Failed to acknowledge assignment", err)
+		return
+	}
+
+	respondCommitted(c, http.StatusOK, gin.H{
+		"message":      "Assignment acknowledged successfully",
+		"assignmentID": id,
+		"unitID":       req.UnitID,
+	}, result)
+}
+
+func declineAssignment(c *gin.Context) {
+	if !requireDispatch(c) {
+		return
+	}
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	var req DeclineAssignmentRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+	setAuditTarget(c, id)
+
+	result, replacement, err := ledger.DeclineAssignment(c.Request.Context(), id, req)
+	if err != nil {
+		respondServiceError(c, "Failed to decline assignment", err)
+		return
+	}
+
+	respondCommitted(c, http.StatusOK, gin.H{
+		"message":      "Assignment declined successfully",
+		"assignmentID": id,
+		"unitID":       req.UnitID,
+		"reassignedTo": replacement,
+	}, result)
+}
+
+func listAssignments(c *gin.Context) {
+	var req AssignmentListRequest
+	if !bindQuery(c, &req) {
+		return
+	}
+	assignments, err := ledger.ListAssignments(c.Request.Context(), req)
+	if err != nil {
+		respondServiceError(c, "Failed to list assignments", err)
+		return
+	}
+	respondData(c, http.StatusOK, assignments)
+}
+
+// rosterUnits lists the units with their roster status. Every unit is available and on shift while
+// dispatch is disabled, as it is to RaiseSOS.
+func rosterUnits(ctx context.Context) ([]DispatchUnit, error) {
+	if dispatcher != nil {
+		return dispatcher.units(ctx, time.Now())
+	}
+	units := make([]DispatchUnit, 0, len(policeUnits))
+	for _, unit := range policeUnits {
+		units = append(units, DispatchUnit{PoliceUnit: unit, Kind: unit.kind(), Status: "available", OnShift: true})
+	}
+	return units, nil
+}
+
+func listDispatchUnits(c *gin.Context) {
+	if !requireDispatch(c) {
+		return
+	}
+	units, err := rosterUnits(c.Request.Context())
+	if err != nil {
+		respondServiceError(c, "Failed to read the unit roster", err)
+		return
+	}
+	respondData(c, http.StatusOK, units)
+}
+
+func setUnitStatus(c *gin.Context) {
+	if !requireDispatch(c) {
+		return
+	}
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	var req UnitStatusRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+	if policeUnitByID(id) == nil {
+		respondError(c, http.StatusNotFound, errCodeNotFound, "Unit not found")
+		return
+	}
+	setAuditTarget(c, id)
+	if err := dispatcher.store.setStatus(c.Request.Context(), id, req.Status); err != nil {
+		respondServiceError(c, "Failed to update unit status", err)
+		return
+	}
+	respondUnit(c, id)
+}
+
+// redisDispatchStore keeps a key per open case, the deadlines in a sorted set scored by time and
+// the roster in hashes of statuses, profiles and positions
+type redisDispatchStore struct {
+	redis *redisClient
+	ttl   time.Duration
+}
+
+func (s redisDispatchStore) loadCase(ctx context.Context, assignmentID string) (*dispatchCase, error) {
+	data, err := s.redis.Get(ctx, dispatchCasePrefix+assignmentID)
+	if errors.Is(err, errRedisNil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var c dispatchCase
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (s redisDispatchStore) schedule(ctx context.Context, c dispatchCase, deadline time.Time) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	if err := s.redis.Set(ctx, dispatchCasePrefix+c.AssignmentID, data, s.ttl); err != nil {
+		return err
+	}
+	_, err = s.redis.Do(ctx, "ZADD", dispatchDeadlinesKey, strconv.FormatInt(deadline.UnixMilli(), 10), c.AssignmentID)
+	return err
+}
+
+func (s redisDispatchStore) unschedule(ctx context.Context, assignmentID string) error {
+	_, err := s.redis.Do(ctx, "ZREM", dispatchDeadlinesKey, assignmentID)
+	return err
+}
+
+// due claims each expired member with ZREM, so only one instance escalates it
+func (s redisDispatchStore) due(ctx context.Context, now time.Time) ([]string, error) {
+	reply, err := s.redis.Do(ctx, "ZRANGEBYSCORE", dispatchDeadlinesKey, "-inf", strconv.FormatInt(now.UnixMilli(), 10), "LIMIT", "0", "100")
+	if err != nil {
+		return nil, err
+	}
+	members, _ := reply.([]interface{})
+	var due []string
+	for _, member := range members {
+		raw, _ := member.([]byte)
+		removed, err := s.redis.Do(ctx, "ZREM", dispatchDeadlinesKey, string(raw))
+		if err != nil {
+			return due, err
+		}
+		if n, _ := removed.(int64); n == 1 {
+			due = append(due, string(raw))
+		}
+	}
+	return due, nil
+}
+
+func (s redisDispatchStore) roster(ctx context.Context) (map[string]string, error) {
+	reply, err := s.redis.Do(ctx, "HGETALL", dispatchRosterKey)
+	if err != nil {
+		return nil, err
+	}
+	fields, _ := reply.([]interface{})
+	roster := make(map[string]string, len(fields)/2)
+	for i := 0; i+1 < len(fields); i += 2 {
+		unitID, _ := fields[i].([]byte)
+		status, _ := fields[i+1].([]byte)
+		roster[string(unitID)] = string(status)
+	}
+	return roster, nil
+}
+
+func (s redisDispatchStore) setStatus(ctx context.Context, unitID, status string) error {
+	_, err := s.redis.Do(ctx, "HSET", dispatchRosterKey, unitID, status)
+	return err
+}
+
+func (s redisDispatchStore) profiles(ctx context.Context) (map[string]UnitProfile, error) {
+	return redisHashJSON[UnitProfile](ctx, s.redis, dispatchProfilesKey)
+}
+
+func (s redisDispatchStore) setProfile(ctx context.Context, profile UnitProfile) error {
+	data, err := json.Marshal(profile)
+	if err != nil {
+		return err
+	}
+	_, err = s.redis.Do(ctx, "HSET", dispatchProfilesKey, profile.UnitID, string(data))
+	return err
+}
+
+func (s redisDispatchStore) positions(ctx context.Context) (map[string]UnitPosition, error) {
+	return redisHashJSON[UnitPosition](ctx, s.redis, dispatchPositionsKey)
+}
+
+func (s redisDispatchStore) setPosition(ctx context.Context, unitID string, position UnitPosition) error {
+	data, err := json.Marshal(position)
+	if err != nil {
+		return err
+	}
+	_, err = s.redis.Do(ctx, "HSET", dispatchPositionsKey, unitID, string(data))
+	return err
+}
+
+// redisHashJSON reads a hash of JSON values by field, skipping values that do not decode
+func redisHashJSON[T any](ctx context.Context, redis *redisClient, key string) (map[string]T, error) {
+	reply, err := redis.Do(ctx, "HGETALL", key)
+	if err != nil {
+		return nil, err
+	}
+	fields, _ := reply.([]interface{})
+	values := make(map[string]T, len(fields)/2)
+	for i := 0; i+1 < len(fields); i += 2 {
+		field, _ := fields[i].([]byte)
+		raw, _ := fields[i+1].([]byte)
+		var value T
+		if json.Unmarshal(raw, &value) == nil {
+			values[string(field)] = value
+		}
+	}
+	return values, nil
+}
+
+// memoryDispatchStore serves a single gateway instance
+type memoryDispatchStore struct {
+	mu            sync.Mutex
+	cases         map[string]dispatchCase
+	deadlines     map[string]time.Time
+	statuses      map[string]string
+	unitProfiles  map[string]UnitProfile
+	unitPositions map[string]UnitPosition
+	unitTracks    map[string][]UnitPosition
+}
+
+func newMemoryDispatchStore() *memoryDispatchStore {
+	return &memoryDispatchStore{
+		cases:         map[string]dispatchCase{},
+		deadlines:     map[string]time.Time{},
+		statuses:      map[string]string{},
+		unitProfiles:  map[string]UnitProfile{},
+		unitPositions: map[string]UnitPosition{},
+		unitTracks:    map[string][]UnitPosition{},
+	}
+}
+
+func (s *memoryDispatchStore) loadCase(_ context.Context, assignmentID string) (*dispatchCase, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.cases[assignmentID]
+	if !ok {
+		return nil, nil
+	}
+	return &c, nil
+}
+
+func (s *memoryDispatchStore) schedule(_ context.Context, c dispatchCase, deadline time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cases[c.AssignmentID], s.deadlines[c.AssignmentID] = c, deadline
+	return nil
+}
+
+func (s *memoryDispatchStore) unschedule(_ context.Context, assignmentID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.deadlines, assignmentID)
+	delete(s.cases, assignmentID)
+	return nil
+}
+
+func (s *memoryDispatchStore) due(_ context.Context, now time.Time) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var due []string
+	for id, deadline := range s.deadlines {
+		if deadline.Before(now) {
+			due = append(due, id)
+			delete(s.deadlines, id)
+		}
+	}
+	sort.Strings(due)
+	return due, nil
+}
+
+func (s *memoryDispatchStore) roster(context.Context) (map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	roster := make(map[string]string, len(s.statuses))
+	for id, status := range s.statuses {
+		roster[id] = status
+	}
+	return roster, nil
+}
+
+func (s *memoryDispatchStore) setStatus(_ context.Context, unitID, status string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.statuses[unitID] = status
+	return nil
+}
+
+func (s *memoryDispatchStore) profiles(context.Context) (map[string]UnitProfile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return maps.Clone(s.unitProfiles), nil
+}
+
+func (s *memoryDispatchStore) setProfile(_ context.Context, profile UnitProfile) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.unitProfiles[profile.UnitID] = profile
+	return nil
+}
+
+func (s *memoryDispatchStore) positions(context.Context) (map[string]UnitPosition, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return maps.Clone(s.unitPositions), nil
+}
+
+func (s *memoryDispatchStore) setPosition(_ context.Context, unitID string, position UnitPosition) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.unitPositions[unitID] = position
+	return nil
+}
@@ -0,0 +1,66 @@
+package gateway
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDispatchSelection(t *testing.T) {
+	previous := policeUnits
+	policeUnits = []PoliceUnit{
+		{ID: "guwahati", Latitude: 26.1445, Longitude: 91.7362},
+		{ID: "dispur", Latitude: 26.1433, Longitude: 91.7898},
+		{ID: "ambulance-1", Kind: unitMedical, Latitude: 26.1000, Longitude: 91.7000},
+		{ID: "shillong", Latitude: 25.5788, Longitude: 91.8933},
+	}
+	defer func() { policeUnits = previous }()
+	ctx := context.Background()
+	d := &dispatchService{store: newMemoryDispatchStore(), ackTimeout: time.Minute, maxAttempts: 3, maxKm: 50}
+
+	// Plain SOS routing ignores medical units even when they are closer
+	if unit, _ := nearestPoliceUnit(policeUnits, 26.10, 91.70, 50); unit == nil || unit.ID != "guwahati" {
+		t.Fatalf("expected Guwahati police, got %+v", unit)
+	}
+	if unit, _, _ := d.nearestAvailable(ctx, unitQuery{kind: unitMedical, lat: 26.10, lng: 91.70}); unit == nil || unit.ID != "ambulance-1" {
+		t.Fatalf("expected the ambulance, got %+v", unit)
+	}
+
+	// Busy units and units already tried are passed over for the next nearest
+	d.store.setStatus(ctx, "guwahati", "busy")
+	if unit, _, _ := d.nearestAvailable(ctx, unitQuery{kind: unitPolice, lat: 26.10, lng: 91.70}); unit == nil || unit.ID != "dispur" {
+		t.Fatalf("expected Dispur while Guwahati is busy, got %+v", unit)
+	}
+	if unit, _, _ := d.nearestAvailable(ctx, unitQuery{kind: unitPolice, lat: 26.10, lng: 91.70, exclude: []string{"dispur"}}); unit != nil {
+		t.Fatalf("expected no police within 50km once Dispur was tried, got %s", unit.ID)
+	}
+	d.store.setStatus(ctx, "guwahati", "available")
+	if unit, _, _ := d.nearestAvailable(ctx, unitQuery{kind: unitPolice, lat: 26.10, lng: 91.70, exclude: []string{"dispur"}}); unit == nil || unit.ID != "guwahati" {
+		t.Fatalf("expected Guwahati back on the roster, got %+v", unit)
+	}
+
+	if id := assignmentID("SOS-1", unitPolice, 2); id != "ASG:SOS-1:police:2" {
+		t.Errorf("unexpected assignment ID %s", id)
+	}
+}
+
+func TestDispatchDeadlines(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryDispatchStore()
+	now := time.Now()
+	store.schedule(ctx, dispatchCase{AssignmentID: "ASG:SOS-1:police:1", SubjectID: "SOS-1"}, now.Add(-time.Second))
+	store.schedule(ctx, dispatchCase{AssignmentID: "ASG:SOS-2:police:1", SubjectID: "SOS-2"}, now.Add(-time.Second))
+	store.schedule(ctx, dispatchCase{AssignmentID: "ASG:SOS-3:police:1", SubjectID: "SOS-3"}, now.Add(time.Minute))
+	store.unschedule(ctx, "ASG:SOS-2:police:1")
+
+	due, _ := store.due(ctx, now)
+	if len(due) != 1 || due[0] != "ASG:SOS-1:police:1" {
+		t.Fatalf("expected only the unacknowledged overdue assignment, got %v", due)
+	}
+	if again, _ := store.due(ctx, now); len(again) != 0 {
+		t.Errorf("expected a claimed assignment to be due once, got %v", again)
+	}
+	if c, _ := store.loadCase(ctx, "ASG:SOS-1:police:1"); c == nil || c.SubjectID != "SOS-1" {
+		t.Errorf("expected the case to stay readable after its claim, got %+v", c)
+	}
+}
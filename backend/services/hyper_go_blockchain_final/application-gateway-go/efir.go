@@ -0,0 +1,465 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+//go:embed templates/efir.tmpl
+var defaultEFIRTemplate string
+
+const (
+	maxEFIRStatementBytes = 8000
+	firAllocationAttempts = 3
+)
+
+// efirStatePattern matches state format names, which are also template file names
+var efirStatePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,31}$`)
+
+// GenerateEFIRRequest carries the narrative fields that are not held on the ledger. With both a
+// district and a police station, the FIR takes the next number in that station's register.
+type GenerateEFIRRequest struct {
+	GeneratedBy   string `json:"generatedBy" binding:"required"`
+	PoliceStation string `json:"policeStation"`
+	District      string `json:"district"`
+	Complainant   string `json:"complainant"`
+	Description   string `json:"description"`
+	// State picks the state's prescribed layout from EFIR_TEMPLATES_DIR; EFIR_DEFAULT_STATE otherwise
+	State string `json:"state"`
+}
+
+func (r GenerateEFIRRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.identifier("generatedBy", r.GeneratedBy)
+	for field, value := range map[string]string{"policeStation": r.PoliceStation, "district": r.District, "complainant": r.Complainant} {
+		if len(value) > 256 {
+			v.add(field, "must be at most 256 characters")
+		}
+	}
+	if len(r.Description) > maxEFIRStatementBytes {
+		v.add("description", "must be at most %d characters", maxEFIRStatementBytes)
+	}
+	if r.State != "" {
+		if _, ok := efirStateTemplates[r.State]; !ok {
+			v.add("state", "has no e-FIR template; available: %s", strings.Join(sortedKeys(efirStateTemplates), ", "))
+		}
+	}
+	return v.errors
+}
+
+// numbered reports whether the FIR is recorded in a police station's register
+func (r GenerateEFIRRequest) numbered() bool {
+	return r.District != "" && r.PoliceStation != ""
+}
+
+// EFIRDocument is a FIR hash anchored on the ledger
+type EFIRDocument struct {
+	DocType       string `json:"doc_type"`
+	FIRID         string `json:"fir_id"`
+	IncidentID    string `json:"incident_id"`
+	DocumentHash  string `json:"document_hash"`
+	GeneratedBy   string `json:"generated_by"`
+	CreatedAt     string `json:"created_at"`
+	FIRNumber     string `json:"fir_number,omitempty"`
+	District      string `json:"district,omitempty"`
+	PoliceStation string `json:"police_station,omitempty"`
+	TxID          string `json:"tx_id"`
+}
+
+// FIRAllocation is a number taken from a police station's FIR register. Status is allocated until
+// the FIR is recorded; an allocation left allocated is a FIR that was never filed.
+type FIRAllocation struct {
+	DocType       string `json:"doc_type"`
+	FIRID         string `json:"fir_id"`
+	FIRNumber     string `json:"fir_number"`
+	District      string `json:"district"`
+	PoliceStation string `json:"police_station"`
+	Year          int    `json:"year"`
+	Sequence      int    `json:"sequence"`
+	IncidentID    string `json:"incident_id"`
+	Status        string `json:"status"`
+	AllocatedBy   string `json:"allocated_by"`
+	AllocatedAt   string `json:"allocated_at"`
+	RecordedAt    string `json:"recorded_at,omitempty"`
+	TxID          string `json:"tx_id"`
+}
+
+// FIRRegisterRequest selects a police station's register for a year, the current one by default
+type FIRRegisterRequest struct {
+	District      string `form:"district" binding:"required"`
+	PoliceStation string `form:"policeStation" binding:"required"`
+	Year          int    `form:"year"`
+}
+
+func (r FIRRegisterRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	if len(r.District) > 256 || len(r.PoliceStation) > 256 {
+		v.add("policeStation", "district and police station must be at most 256 characters")
+	}
+	if r.Year != 0 && (r.Year < 2000 || r.Year > 9999) {
+		v.add("year", "must be a four-digit year")
+	}
+	return v.errors
+}
+
+// FIRRegister is a station's register with the gaps left by numbers that were never filed
+type FIRRegister struct {
+	District      string          `json:"district"`
+	PoliceStation string          `json:"police_station"`
+	Year          int             `json:"year"`
+	Allocations   []FIRAllocation `json:"allocations"`
+	Unfiled       []string        `json:"unfiled"`
+}
+
+// EFIRData is the input to the e-FIR template. Tourist is the verdict on the incident's subject
+// DID, when it has one, and EvidenceManifestHash commits to the evidence list as printed.
+type EFIRData struct {
+	GenerateEFIRRequest
+	FIRID                string
+	FIRNumber            string
+	GeneratedAt          string
+	Incident             IncidentDocument
+	Tourist              *DIDVerification
+	Evidence             []EvidenceDocument
+	EvidenceManifestHash string
+	Audits               []AuditDocument
+}
+
+// efirStorageKey is where an e-FIR's PDF lives in the evidence store
+func efirStorageKey(incidentID, firID string) string {
+	return fmt.Sprintf("efir/%s/%s.pdf", incidentID, firID)
+}
+
+// EFIRResult is a generated FIR and where its hash was anchored
+type EFIRResult struct {
+	FIRID        string
+	FIRNumber    string
+	IncidentID   string
+	DocumentHash string
+	StorageKey   string
+	Document     []byte
+	Transaction  *TransactionResult
+}
+
+var (
+	efirTemplate = template.Must(template.New("efir").Parse(defaultEFIRTemplate))
+	// efirStateTemplates are the states' prescribed layouts, by state name
+	efirStateTemplates = map[string]*template.Template{}
+	efirDefaultState   string
+)
+
+// initEFIRTemplate replaces the built-in layout with EFIR_TEMPLATE_FILE when set, and loads a
+// <state>.tmpl layout per state from EFIR_TEMPLATES_DIR
+func initEFIRTemplate() {
+	if path := getEnv("EFIR_TEMPLATE_FILE", ""); path != "" {
+		source, err := os.ReadFile(path)
+		if err != nil {
+			panic(fmt.Errorf("failed to read e-FIR template: %w", err))
+		}
+		efirTemplate = template.Must(template.New("efir").Parse(string(source)))
+	}
+	if dir := getEnv("EFIR_TEMPLATES_DIR", ""); dir != "" {
+		paths, err := filepath.Glob(filepath.Join(dir, "*.tmpl"))
+		if err != nil {
+			panic(fmt.Errorf("failed to list e-FIR templates: %w", err))
+		}
+		for _, path := range paths {
+			state := strings.TrimSuffix(filepath.Base(path), ".tmpl")
+			if !efirStatePattern.MatchString(state) {
+				panic(fmt.Errorf("e-FIR template %s must be named <state>.tmpl in lowercase", path))
+			}
+			source, err := os.ReadFile(path)
+			if err != nil {
+				panic(fmt.Errorf("failed to read e-FIR template: %w", err))
+			}
+			efirStateTemplates[state] = template.Must(template.New(state).Parse(string(source)))
+		}
+	}
+	efirDefaultState = getEnv("EFIR_DEFAULT_STATE", "")
+	if _, ok := efirStateTemplates[efirDefaultState]; efirDefaultState != "" && !ok {
+		panic(fmt.Errorf("EFIR_DEFAULT_STATE %s has no template in EFIR_TEMPLATES_DIR", efirDefaultState))
+	}
+}
+
+// efirTemplateFor returns the state's layout, the default state's, or the built-in one
+func efirTemplateFor(state string) *template.Template {
+	if state == "" {
+		state = efirDefaultState
+	}
+	if tmpl, ok := efirStateTemplates[state]; ok {
+		return tmpl
+	}
+	return efirTemplate
+}
+
+// evidenceManifestHash is the SHA-256 over one "<evidence ID> <SHA-256>" line per item, in the
+// order the FIR lists them
+func evidenceManifestHash(evidence []EvidenceDocument) string {
+	h := sha256.New()
+	for _, e := range evidence {
+		fmt.Fprintf(h, "%s %s\n", e.EvidenceID, e.EvidenceHash)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// GenerateEFIR renders a FIR for an incident from its ledger record, the subject's DID, evidence and
+// audit trail, stores the PDF alongside the evidence files and anchors its SHA-256 on the ledger.
+// A numbered FIR first takes its number from the station's register, so the number is printed in
+// the document whose hash is anchored.
+func (ledgerService) GenerateEFIR(ctx context.Context, incidentID string, req GenerateEFIRRequest) (*EFIRResult, error) {
+	if err := validateMutation(incidentID, req); err != nil {
+		return nil, err
+	}
+
+	incident, err := ledger.ownedIncident(ctx, incidentID)
+	if err != nil {
+		return nil, err
+	}
+	// The FIR is a legal record, so it is built from committed ledger state rather than the index
+	evidence, err := evidenceByIncidentFromLedger(ctx, incidentID, EvidenceListRequest{})
+	if err != nil {
+		return nil, err
+	}
+	audits, err := auditsByTargetFromLedger(ctx, incidentID)
+	if err != nil {
+		return nil, err
+	}
+	var tourist *DIDVerification
+	if incident.SubjectID != "" {
+		verdict, err := ledger.VerifyDID(ctx, incident.SubjectID)
+		if err != nil {
+			return nil, err
+		}
+		tourist = &verdict
+	}
+
+	now := time.Now().UTC()
+	suffix := make([]byte, 3)
+	rand.Read(suffix)
+	data := EFIRData{
+		GenerateEFIRRequest:  req,
+		FIRID:                fmt.Sprintf("FIR-%s-%s", now.Format("20060102T150405Z"), hex.EncodeToString(suffix)),
+		GeneratedAt:          now.Format(time.RFC3339),
+		Incident:             incident,
+		Tourist:              tourist,
+		Evidence:             evidence,
+		EvidenceManifestHash: evidenceManifestHash(evidence),
+		Audits:               audits,
+	}
+	if req.numbered() {
+		allocation, err := allocateFIRNumber(ctx, data.FIRID, incidentID, req)
+		if err != nil {
+			return nil, err
+		}
+		data.FIRNumber = allocation.FIRNumber
+	}
+
+	document, err := renderEFIR(data)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(document)
+	result := &EFIRResult{
+		FIRID:        data.FIRID,
+		FIRNumber:    data.FIRNumber,
+		IncidentID:   incidentID,
+		DocumentHash: hex.EncodeToString(sum[:]),
+		StorageKey:   efirStorageKey(incidentID, data.FIRID),
+		Document:     document,
+	}
+
+	if err := evidenceStore.Put(ctx, result.StorageKey, bytes.NewReader(document), int64(len(document)), "application/pdf"); err != nil {
+		return nil, fmt.Errorf("failed to store e-FIR document: %w", err)
+	}
+	result.Transaction, err = submitTransaction(ctx, "RecordEFIR", result.FIRID, incidentID, result.DocumentHash, req.GeneratedBy)
+	if err != nil {
+		// Do not keep a document the ledger has no record of
+		if delErr := evidenceStore.Delete(ctx, result.StorageKey); delErr != nil {
+			logWithContext(ctx, "Failed to remove orphaned e-FIR %s: %v", result.StorageKey, delErr)
+		}
+		return nil, err
+	}
+	return result, nil
+}
+
+// allocateFIRNumber takes the next number in the station's register, retrying when a concurrent
+// allocation for the same station wins the counter
+func allocateFIRNumber(ctx context.Context, firID, incidentID string, req GenerateEFIRRequest) (FIRAllocation, error) {
+	for attempt := 1; ; attempt++ {
+		result, err := submitTransaction(ctx, "AllocateFIRNumber", firID, incidentID, req.District, req.PoliceStation, req.GeneratedBy)
+		if err == nil {
+			return decodeDocument[FIRAllocation](result.Payload, "FIR allocation")
+		}
+		if attempt == firAllocationAttempts || translateFabricError(err).Code != errCodeMVCCConflict {
+			return FIRAllocation{}, err
+		}
+		logWithContext(ctx, "FIR number allocation for %s conflicted, retrying", req.PoliceStation)
+	}
+}
+
+// GetEFIR returns the ledger record of a generated FIR
+func (s ledgerService) GetEFIR(ctx context.Context, firID string) (EFIRDocument, error) {
+	result, err := s.readDocument(ctx, "ReadEFIR", firID)
+	if err != nil {
+		return EFIRDocument{}, err
+	}
+	return decodeDocument[EFIRDocument](result, "e-FIR")
+}
+
+// FIRRegister lists a station's allocated numbers for a year and the ones never filed
+func (ledgerService) FIRRegister(ctx context.Context, req FIRRegisterRequest) (FIRRegister, error) {
+	if errs := req.Validate(); len(errs) > 0 {
+		return FIRRegister{}, errs
+	}
+	if req.Year == 0 {
+		req.Year = time.Now().UTC().Year()
+	}
+	result, err := evaluateTransaction(ctx, "GetFIRRegister", req.District, req.PoliceStation, strconv.Itoa(req.Year))
+	if err != nil {
+		return FIRRegister{}, err
+	}
+	allocations, err := decodeDocument[[]FIRAllocation](result, "FIR register")
+	if err != nil {
+		return FIRRegister{}, err
+	}
+	register := FIRRegister{District: req.District, PoliceStation: req.PoliceStation, Year: req.Year, Allocations: allocations, Unfiled: []string{}}
+	if register.Allocations == nil {
+		register.Allocations = []FIRAllocation{}
+	}
+	for _, allocation := range register.Allocations {
+		if allocation.Status != "recorded" {
+			register.Unfiled = append(register.Unfiled, allocation.FIRNumber)
+		}
+	}
+	return register, nil
+}
+
+// renderEFIR executes the template and lays out its output. Each line of the template output is
+// one block: "# " title, "## " section heading, "= label | value" field, "- " list item,
+// "---" rule, blank line spacing, and anything else a paragraph.
+func renderEFIR(data EFIRData) ([]byte, error) {
+	var source bytes.Buffer
+	if err := efirTemplateFor(data.State).Execute(&source, data); err != nil {
+		return nil, fmt.Errorf("failed to render e-FIR template: %w", err)
+	}
+
+	doc := newPDFDocument()
+	scanner := bufio.NewScanner(&source)
+	scanner.Buffer(make([]byte, 64*1024), maxEFIRStatementBytes*2)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " ")
+		switch {
+		case line == "":
+			doc.Space(6)
+		case line == "---":
+			doc.Rule()
+		case strings.HasPrefix(line, "## "):
+			doc.Space(6)
+			doc.Paragraph(strings.TrimPrefix(line, "## "), 12, true)
+			doc.Rule()
+		case strings.HasPrefix(line, "# "):
+			doc.Centered(strings.TrimPrefix(line, "# "), 16, true)
+		case strings.HasPrefix(line, "= "):
+			label, value, _ := strings.Cut(strings.TrimPrefix(line, "= "), "|")
+			doc.Field(strings.TrimSpace(label), strings.TrimSpace(value), 10)
+		case strings.HasPrefix(line, "- "):
+			doc.Indented(pdfMargin+12, "- "+strings.TrimPrefix(line, "- "), 9, false)
+		default:
+			doc.Paragraph(line, 10, false)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to render e-FIR template: %w", err)
+	}
+	return doc.Bytes(), nil
+}
+
+// generateEFIR returns the PDF itself when the client accepts application/pdf, otherwise the
+// standard envelope with the document base64 encoded
+func generateEFIR(c *gin.Context) {
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	var req GenerateEFIRRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+
+	result, err := ledger.GenerateEFIR(c.Request.Context(), id, req)
+	if err != nil {
+		respondServiceError(c, "Failed to generate e-FIR", err)
+		return
+	}
+
+	if c.NegotiateFormat(gin.MIMEJSON, "application/pdf") == "application/pdf" {
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.pdf"`, result.FIRID))
+		c.Header("X-FIR-ID", result.FIRID)
+		if result.FIRNumber != "" {
+			c.Header("X-FIR-Number", result.FIRNumber)
+		}
+		c.Header("X-Document-Hash", result.DocumentHash)
+		c.Header("X-Transaction-ID", result.Transaction.TxID)
+		c.Header("X-Block-Number", strconv.FormatUint(result.Transaction.BlockNumber, 10))
+		c.Data(http.StatusCreated, "application/pdf", result.Document)
+		return
+	}
+
+	respondCommitted(c, http.StatusCreated, gin.H{
+		"message":      "e-FIR generated successfully",
+		"firID":        result.FIRID,
+		"firNumber":    result.FIRNumber,
+		"incidentID":   result.IncidentID,
+		"documentHash": result.DocumentHash,
+		"storageKey":   result.StorageKey,
+		"document":     result.Document,
+	}, result.Transaction)
+}
+
+func getEFIR(c *gin.Context) {
+	id, ok := validPathID(c, "firId")
+	if !ok {
+		return
+	}
+	fir, err := ledger.GetEFIR(c.Request.Context(), id)
+	if err != nil {
+		respondServiceError(c, "Failed to get e-FIR", err)
+		return
+	}
+	respondData(c, http.StatusOK, fir)
+}
+
+func getFIRRegister(c *gin.Context) {
+	var req FIRRegisterRequest
+	if !bindQuery(c, &req) {
+		return
+	}
+	register, err := ledger.FIRRegister(c.Request.Context(), req)
+	if err != nil {
+		respondServiceError(c, "Failed to read the FIR register", err)
+		return
+	}
+	respondData(c, http.StatusOK, register)
+}
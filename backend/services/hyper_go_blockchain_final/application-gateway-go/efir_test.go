@@ -0,0 +1,92 @@
+package gateway
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+func TestRenderEFIR(t *testing.T) {
+	data := EFIRData{
+		GenerateEFIRRequest: GenerateEFIRRequest{GeneratedBy: "officer-7", PoliceStation: "Shillong Sadar (East)", Description: strings.Repeat("The tourist reported a theft near the market. ", 120)},
+		FIRID:               "FIR-20250101T000000Z-abcdef",
+		GeneratedAt:         "2025-01-01T00:00:00Z",
+		Incident:            IncidentDocument{IncidentID: "INC-1", IncidentSummaryHash: validHash, Reporter: "tourist_app", Severity: "high"},
+		Evidence:            []EvidenceDocument{{EvidenceID: "EV-1", EvidenceHash: validHash, MediaType: "image/jpeg", UploadedBy: "officer-7"}},
+	}
+
+	document, err := renderEFIR(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(document, []byte("%PDF-1.4")) || !bytes.HasSuffix(document, []byte("%%EOF\n")) {
+		t.Fatal("document is not framed as a PDF")
+	}
+	for _, want := range []string{"(FIR-20250101T000000Z-abcdef)", `(Shillong Sadar \(East\))`, "(No audit entries were found.)", "(Page 2 of 2)"} {
+		if !bytes.Contains(document, []byte(want)) {
+			t.Errorf("document is missing %s", want)
+		}
+	}
+
+	// Every xref entry must point at the object it names
+	xref := regexp.MustCompile(`(\d{10}) 00000 n`).FindAllSubmatch(document, -1)
+	for i, entry := range xref {
+		offset, _ := strconv.Atoi(string(entry[1]))
+		if want := []byte(strconv.Itoa(i+1) + " 0 obj"); !bytes.HasPrefix(document[offset:], want) {
+			t.Errorf("xref entry %d points at %q", i+1, document[offset:offset+10])
+		}
+	}
+}
+
+func TestWrapTextBreaksLongWords(t *testing.T) {
+	lines := wrapText("hash "+validHash, 10, 100, false)
+	for _, line := range lines {
+		if textWidth(line, 10, false) > 100 {
+			t.Errorf("line %q is wider than 100pt", line)
+		}
+	}
+	if joined := strings.Join(lines, ""); strings.ReplaceAll(joined, " ", "") != "hash"+validHash {
+		t.Errorf("wrapping lost text: %q", lines)
+	}
+}
+
+func TestEFIRNumberingAndStateTemplates(t *testing.T) {
+	evidence := []EvidenceDocument{{EvidenceID: "EV-1", EvidenceHash: validHash}}
+	data := EFIRData{
+		GenerateEFIRRequest:  GenerateEFIRRequest{GeneratedBy: "officer-7", District: "East Khasi Hills", PoliceStation: "Sadar"},
+		FIRID:                "FIR-20250101T000000Z-abcdef",
+		FIRNumber:            "0042/2025",
+		Incident:             IncidentDocument{IncidentID: "INC-1", SubjectID: "did:sih:tourist_001"},
+		Tourist:              &DIDVerification{DigitalID: "did:sih:tourist_001", Exists: true, Expired: true, Reason: "expired", VerifiedAt: "2025-01-01T00:00:00Z"},
+		Evidence:             evidence,
+		EvidenceManifestHash: evidenceManifestHash(evidence),
+	}
+	if !data.numbered() || (GenerateEFIRRequest{District: "East Khasi Hills"}).numbered() {
+		t.Error("expected only FIRs with a district and police station to be numbered")
+	}
+	document, err := renderEFIR(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"(0042/2025)", "(did:sih:tourist_001)", "(expired, verified 2025-01-01T00:00:00Z)", "(" + data.EvidenceManifestHash + ")"} {
+		if !bytes.Contains(document, []byte(want)) {
+			t.Errorf("document is missing %s", want)
+		}
+	}
+	if evidenceManifestHash(nil) == data.EvidenceManifestHash {
+		t.Error("expected the manifest hash to change with the evidence list")
+	}
+
+	efirStateTemplates["meghalaya"] = template.Must(template.New("meghalaya").Parse("# FORM IF-1 (MEGHALAYA)\n= FIR No. | {{.FIRNumber}}\n"))
+	defer delete(efirStateTemplates, "meghalaya")
+	data.State = "meghalaya"
+	if document, err = renderEFIR(data); err != nil || !bytes.Contains(document, []byte("(FORM IF-1 \\(MEGHALAYA\\))")) {
+		t.Errorf("expected the Meghalaya layout, got error %v", err)
+	}
+	if errs := (GenerateEFIRRequest{GeneratedBy: "officer-7", State: "assam"}).Validate(); len(errs) != 1 || errs[0].Field != "state" {
+		t.Errorf("expected a state without a template to be rejected, got %v", errs)
+	}
+}
@@ -0,0 +1,512 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+const (
+	emailOptOutKey = "sih:email:optout"
+
+	errCodeEmailDisabled = "EMAIL_DISABLED"
+)
+
+// defaultEmailTemplates are used unless EMAIL_TEMPLATES_DIR has a <name>.tmpl of the same name.
+// The first line is the subject and the rest, after a blank line, is the plain-text body.
+var defaultEmailTemplates = map[string]string{
+	"incident_acknowledged": `Incident {{.IncidentID}} has been acknowledged
+
+Dear {{.RecipientName}},
+
+The incident you reported ({{.IncidentID}}) was acknowledged by the police at {{.Time}}. An officer is looking into it and you will be told when there is an update.
+{{template "footer" .}}`,
+	"fir_generated": `e-FIR {{.FIRID}} filed for incident {{.IncidentID}}
+
+Dear {{.RecipientName}},
+
+A First Information Report, {{.FIRID}}, was filed for the incident you reported ({{.IncidentID}}) at {{.Time}}. Its fingerprint is recorded on the tourist safety ledger, so any copy you are given can be verified.
+{{template "footer" .}}`,
+	"case_closed": `Incident {{.IncidentID}} has been closed
+
+Dear {{.RecipientName}},
+
+The case for the incident you reported ({{.IncidentID}}) was closed at {{.Time}}. Thank you for reporting it.
+{{template "footer" .}}`,
+	"contact_verification": `Confirm you are an emergency contact
+
+Dear {{.RecipientName}},
+
+Tourist {{.DigitalID}} has named you as an emergency contact, to be told if they raise an SOS. To confirm this address, give them the code {{.Code}}. Do not share it with anyone else. If you do not know this tourist, ignore this email.
+{{template "footer" .}}`,
+	"operations_report": `{{with .Report}}Tourist safety {{.Report.Period}} report {{.Report.ReportID}}
+
+The {{.Report.Period}} operations report for {{.Report.PeriodStart}} to {{.Report.PeriodEnd}} is attached as a PDF and a CSV. It covers {{.Report.Incidents.Total}} incidents, of which {{.Report.Response.Incidents.Acknowledged}} were acknowledged, and {{.Report.DIDs.Issued}} digital IDs issued.
+
+Both files are fingerprinted on the tourist safety ledger in transaction {{.Anchor.TxID}}. PDF SHA-256: {{.Anchor.PDFHash}}, CSV SHA-256: {{.Anchor.CSVHash}}.{{end}}
+{{template "footer" .}}`,
+	"footer": `
+--
+Smart Tourist Safety
+{{if .UnsubscribeURL}}To stop these emails, visit {{.UnsubscribeURL}}
+{{end}}`,
+}
+
+// EmailPreference is whether a user receives incident update emails
+type EmailPreference struct {
+	UserID   string `json:"user_id"`
+	OptedOut bool   `json:"opted_out"`
+}
+
+// UpdateEmailPreferenceRequest opts a user out of, or back into, incident update emails
+type UpdateEmailPreferenceRequest struct {
+	OptedOut *bool `json:"optedOut" binding:"required"`
+}
+
+// UserContact is a user's registered name and email address
+type UserContact struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+// userDirectory resolves the address an incident reporter registered
+type userDirectory interface {
+	user(ctx context.Context, userID string) (*UserContact, error)
+}
+
+// emailOptOutStore keeps opt-outs in Redis when the document cache is configured, and in memory
+// otherwise
+type emailOptOutStore interface {
+	optedOut(ctx context.Context, userID string) (bool, error)
+	set(ctx context.Context, userID string, optedOut bool) error
+}
+
+type emailData struct {
+	RecipientName  string
+	IncidentID     string
+	FIRID          string
+	Status         string
+	Time           string
+	UnsubscribeURL string
+	DigitalID      string
+	Code           string
+	Report         *GeneratedReport
+}
+
+type emailJob struct {
+	ctx   context.Context
+	event *client.ChaincodeEvent
+}
+
+// emailNotifier sends incident updates to reporters from a queue fed by chaincode events, so a
+// slow mail server never holds up the event stream
+type emailNotifier struct {
+	mailer    mailSender
+	templates *template.Template
+	users     userDirectory
+	optOuts   emailOptOutStore
+	queue     chan emailJob
+	workers   int
+	retries   int
+	backoff   time.Duration
+
+	publicURL         string
+	unsubscribeSecret []byte
+}
+
+var emailer *emailNotifier
+
+// initEmail enables incident update emails when SMTP_HOST is set. Runs after initDocumentCache so
+// opt-outs can be shared through Redis.
+func initEmail() {
+	host := getEnv("SMTP_HOST", "")
+	if host == "" {
+		log.Println("✉️  SMTP_HOST not set, incident update emails disabled")
+		return
+	}
+	users, err := newUserDirectoryFromEnv()
+	if err != nil {
+		panic(err)
+	}
+	templates, err := loadEmailTemplates(getEnv("EMAIL_TEMPLATES_DIR", ""))
+	if err != nil {
+		panic(fmt.Errorf("failed to load email templates: %w", err))
+	}
+
+	var optOuts emailOptOutStore = newMemoryOptOutStore()
+	if documentCache != nil {
+		optOuts = redisOptOutStore{documentCache}
+	}
+	emailer = &emailNotifier{
+		mailer: &smtpMailer{
+			addr:     fmt.Sprintf("%s:%d", host, getEnvInt("SMTP_PORT", 587)),
+			host:     host,
+			username: getEnv("SMTP_USERNAME", ""),
+			password: getEnv("SMTP_PASSWORD", ""),
+			from:     getEnv("EMAIL_FROM", "no-reply@"+host),
+			tlsMode:  getEnv("SMTP_TLS", "starttls"),
+			timeout:  getEnvDuration("SMTP_TIMEOUT", 30*time.Second),
+		},
+		templates:         templates,
+		users:             users,
+		optOuts:           optOuts,
+		queue:             make(chan emailJob, getEnvInt("EMAIL_QUEUE_SIZE", 1000)),
+		workers:           getEnvInt("EMAIL_WORKERS", 2),
+		retries:           getEnvInt("EMAIL_RETRIES", 3),
+		backoff:           getEnvDuration("EMAIL_RETRY_BACKOFF", 30*time.Second),
+		publicURL:         strings.TrimSuffix(getEnv("PUBLIC_URL", ""), "/"),
+		unsubscribeSecret: []byte(getEnv("EMAIL_UNSUBSCRIBE_SECRET", "")),
+	}
+	log.Printf("✉️  Sending incident update emails through %s", host)
+}
+
+func newUserDirectoryFromEnv() (userDirectory, error) {
+	if rawURL := getEnv("USER_DIRECTORY_URL", ""); rawURL != "" {
+		return httpUserDirectory{urlTemplate: rawURL, client: &http.Client{Timeout: 10 * time.Second}}, nil
+	}
+	path := getEnv("USER_DIRECTORY_FILE", "")
+	if path == "" {
+		return nil, errors.New("SMTP_HOST is set but neither USER_DIRECTORY_URL nor USER_DIRECTORY_FILE is")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read user directory: %w", err)
+	}
+	var directory fileUserDirectory
+	if err := json.Unmarshal(data, &directory); err != nil {
+		return nil, fmt.Errorf("failed to parse user directory: %w", err)
+	}
+	return directory, nil
+}
+
+func loadEmailTemplates(dir string) (*template.Template, error) {
+	templates := template.New("email").Option("missingkey=error")
+	for name, source := range defaultEmailTemplates {
+		if dir != "" {
+			if data, err := os.ReadFile(filepath.Join(dir, name+".tmpl")); err == nil {
+				source = string(data)
+			} else if !errors.Is(err, os.ErrNotExist) {
+				return nil, err
+			}
+		}
+		if _, err := templates.New(name).Parse(source); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return templates, nil
+}
+
+// emailFromEvent queues the event for the email workers. ctx carries the target the event was
+// received from, for reading the incident back.
+func emailFromEvent(ctx context.Context, event *client.ChaincodeEvent) {
+	if emailer == nil {
+		return
+	}
+	switch event.EventName {
+	case "UpdateIncidentStatus", "RecordEFIR":
+	default:
+		return
+	}
+	select {
+	case emailer.queue <- emailJob{ctx: ctx, event: event}:
+	default:
+		log.Printf("✉️  Email queue full, dropping %s for transaction %s", event.EventName, event.TransactionID)
+	}
+}
+
+// run processes queued events until ctx is cancelled
+func (e *emailNotifier) run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for range max(e.workers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case job := <-e.queue:
+					if err := e.process(job.ctx, job.event); err != nil {
+						log.Printf("✉️  Failed to email update for %s in transaction %s: %v", job.event.EventName, job.event.TransactionID, err)
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// process works out which update an event is and who reported the incident, then emails them
+// unless they opted out
+func (e *emailNotifier) process(ctx context.Context, event *client.ChaincodeEvent) error {
+	var templateName string
+	var incident IncidentDocument
+	data := emailData{}
+	switch event.EventName {
+	case "UpdateIncidentStatus":
+		var err error
+		if incident, err = decodeDocument[IncidentDocument](event.Payload, "incident event"); err != nil {
+			return err
+		}
+		switch incident.Status {
+		case "acknowledged":
+			templateName, data.Time = "incident_acknowledged", incident.AcknowledgedAt
+		case "closed":
+			templateName = "case_closed"
+		default:
+			return nil
+		}
+	case "RecordEFIR":
+		var fir struct {
+			FIRID      string `json:"fir_id"`
+			IncidentID string `json:"incident_id"`
+			CreatedAt  string `json:"created_at"`
+		}
+		if err := json.Unmarshal(event.Payload, &fir); err != nil {
+			return err
+		}
+		var err error
+		if incident, err = ledger.GetIncident(ctx, fir.IncidentID); err != nil {
+			return fmt.Errorf("failed to read incident %s: %w", fir.IncidentID, err)
+		}
+		templateName = "fir_generated"
+		data.FIRID, data.Time = fir.FIRID, fir.CreatedAt
+	default:
+		return nil
+	}
+	data.IncidentID, data.Status = incident.IncidentID, incident.Status
+	if data.Time == "" {
+		data.Time = time.Now().UTC().Format(time.RFC3339)
+	}
+
+	if first, err := claimEvent(ctx, "email:"+event.TransactionID+":"+event.EventName, 24*time.Hour); err != nil || !first {
+		return err
+	}
+	if optedOut, err := e.optOuts.optedOut(ctx, incident.Reporter); err != nil || optedOut {
+		return err
+	}
+	user, err := e.users.user(ctx, incident.Reporter)
+	if err != nil {
+		return fmt.Errorf("failed to look up %s: %w", incident.Reporter, err)
+	}
+	if user == nil || user.Email == "" {
+		return nil
+	}
+	data.RecipientName = user.Name
+	if data.RecipientName == "" {
+		data.RecipientName = incident.Reporter
+	}
+	data.UnsubscribeURL = e.unsubscribeURL(incident.Reporter)
+
+	message, err := e.render(templateName, data)
+	if err != nil {
+		return err
+	}
+	message.to = user.Email
+	for attempt := 0; ; attempt++ {
+		err = e.mailer.send(ctx, message)
+		if err == nil || attempt >= e.retries {
+			return err
+		}
+		log.Printf("✉️  Sending %s to %s failed, retrying: %v", templateName, incident.Reporter, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(e.backoff << attempt):
+		}
+	}
+}
+
+func (e *emailNotifier) render(name string, data emailData) (emailMessage, error) {
+	var out strings.Builder
+	if err := e.templates.ExecuteTemplate(&out, name, data); err != nil {
+		return emailMessage{}, err
+	}
+	subject, body, _ := strings.Cut(out.String(), "\n")
+	return emailMessage{subject: strings.TrimSpace(subject), body: strings.TrimLeft(body, "\n"), unsubscribeURL: data.UnsubscribeURL}, nil
+}
+
+// unsubscribeURL links to the public opt-out page, signed so a link only opts out the user it was
+// sent to. It is empty unless PUBLIC_URL and EMAIL_UNSUBSCRIBE_SECRET are set.
+func (e *emailNotifier) unsubscribeURL(userID string) string {
+	if e.publicURL == "" || len(e.unsubscribeSecret) == 0 {
+		return ""
+	}
+	query := url.Values{"user": {userID}, "token": {e.unsubscribeToken(userID)}}
+	return e.publicURL + "/notifications/unsubscribe?" + query.Encode()
+}
+
+func (e *emailNotifier) unsubscribeToken(userID string) string {
+	mac := hmac.New(sha256.New, e.unsubscribeSecret)
+	mac.Write([]byte(userID))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// fileUserDirectory maps user IDs to contacts, loaded from USER_DIRECTORY_FILE
+type fileUserDirectory map[string]UserContact
+
+func (d fileUserDirectory) user(_ context.Context, userID string) (*UserContact, error) {
+	if contact, ok := d[userID]; ok {
+		return &contact, nil
+	}
+	return nil, nil
+}
+
+// httpUserDirectory asks the user registry for a contact. urlTemplate contains {userID}, and the
+// registry answers with a JSON contact or 404 when there is none.
+type httpUserDirectory struct {
+	urlTemplate string
+	client      *http.Client
+}
+
+func (d httpUserDirectory) user(ctx context.Context, userID string) (*UserContact, error) {
+	target := strings.ReplaceAll(d.urlTemplate, "{userID}", url.PathEscape(userID))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		var contact UserContact
+		if err := json.NewDecoder(resp.Body).Decode(&contact); err != nil {
+			return nil, fmt.Errorf("invalid user response: %w", err)
+		}
+		return &contact, nil
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, errors.New("user registry responded with " + resp.Status)
+	}
+}
+
+type redisOptOutStore struct {
+	redis *redisClient
+}
+
+func (s redisOptOutStore) optedOut(ctx context.Context, userID string) (bool, error) {
+	reply, err := s.redis.Do(ctx, "SISMEMBER", emailOptOutKey, userID)
+	if err != nil {
+		return false, err
+	}
+	member, _ := reply.(int64)
+	return member == 1, nil
+}
+
+func (s redisOptOutStore) set(ctx context.Context, userID string, optedOut bool) error {
+	command := "SREM"
+	if optedOut {
+		command = "SADD"
+	}
+	_, err := s.redis.Do(ctx, command, emailOptOutKey, userID)
+	return err
+}
+
+type memoryOptOutStore struct {
+	mu    sync.Mutex
+	users map[string]bool
+}
+
+func newMemoryOptOutStore() *memoryOptOutStore {
+	return &memoryOptOutStore{users: map[string]bool{}}
+}
+
+func (s *memoryOptOutStore) optedOut(_ context.Context, userID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.users[userID], nil
+}
+
+func (s *memoryOptOutStore) set(_ context.Context, userID string, optedOut bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if optedOut {
+		s.users[userID] = true
+	} else {
+		delete(s.users, userID)
+	}
+	return nil
+}
+
+func emailDisabled(c *gin.Context) bool {
+	if emailer == nil {
+		respondError(c, http.StatusServiceUnavailable, errCodeEmailDisabled, "Email notifications are not configured")
+		return true
+	}
+	return false
+}
+
+func getEmailPreference(c *gin.Context) {
+	userID, ok := validPathID(c, "userId")
+	if !ok || emailDisabled(c) {
+		return
+	}
+
+	optedOut, err := emailer.optOuts.optedOut(c.Request.Context(), userID)
+	if err != nil {
+		respondServiceError(c, "Failed to read email preference", err)
+		return
+	}
+	respondData(c, http.StatusOK, EmailPreference{UserID: userID, OptedOut: optedOut})
+}
+
+func updateEmailPreference(c *gin.Context) {
+	userID, ok := validPathID(c, "userId")
+	if !ok || emailDisabled(c) {
+		return
+	}
+	var req UpdateEmailPreferenceRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+
+	if err := emailer.optOuts.set(c.Request.Context(), userID, *req.OptedOut); err != nil {
+		respondServiceError(c, "Failed to update email preference", err)
+		return
+	}
+	respondData(c, http.StatusOK, EmailPreference{UserID: userID, OptedOut: *req.OptedOut})
+}
+
+// unsubscribeEmail is the page unsubscribe links open. It takes no API key; the token proves the
+// link was sent to the user.
+func unsubscribeEmail(c *gin.Context) {
+	userID, token := c.Query("user"), c.Query("token")
+	if emailer == nil || len(emailer.unsubscribeSecret) == 0 || userID == "" ||
+		!hmac.Equal([]byte(token), []byte(emailer.unsubscribeToken(userID))) {
+		c.String(http.StatusForbidden, "This unsubscribe link is not valid.\n")
+		return
+	}
+	if err := emailer.optOuts.set(c.Request.Context(), userID, true); err != nil {
+		log.Printf("✉️  Failed to unsubscribe %s: %v", userID, err)
+		c.String(http.StatusServiceUnavailable, "Unsubscribing failed, please try again later.\n")
+		return
+	}
+	c.String(http.StatusOK, "You will no longer receive incident update emails.\n")
+}
@@ -0,0 +1,79 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+type recordingMailer struct {
+	sent []emailMessage
+}
+
+func (m *recordingMailer) send(_ context.Context, message emailMessage) error {
+	m.sent = append(m.sent, message)
+	return nil
+}
+
+func TestIncidentUpdateEmails(t *testing.T) {
+	templates, err := loadEmailTemplates("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mailer := &recordingMailer{}
+	emailer = &emailNotifier{
+		mailer:            mailer,
+		templates:         templates,
+		users:             fileUserDirectory{"reporter_1": {Name: "Meera", Email: "meera@example.com"}},
+		optOuts:           newMemoryOptOutStore(),
+		publicURL:         "https://sih.example.gov.in",
+		unsubscribeSecret: []byte("secret"),
+	}
+	defer func() { emailer = nil }()
+	ctx := context.Background()
+	event := func(tx, status string) *client.ChaincodeEvent {
+		return &client.ChaincodeEvent{TransactionID: tx, EventName: "UpdateIncidentStatus",
+			Payload: []byte(`{"incident_id":"inc_1","reporter":"reporter_1","status":"` + status + `","acknowledged_at":"2025-09-20T13:31:42Z"}`)}
+	}
+
+	if err := emailer.process(ctx, event("tx1", "acknowledged")); err != nil {
+		t.Fatal(err)
+	}
+	if err := emailer.process(ctx, event("tx2", "resolved")); err != nil {
+		t.Fatal(err)
+	}
+	if len(mailer.sent) != 1 {
+		t.Fatalf("expected one email for the acknowledgement, got %d", len(mailer.sent))
+	}
+	message := mailer.sent[0]
+	if message.to != "meera@example.com" || message.subject != "Incident inc_1 has been acknowledged" ||
+		!strings.HasPrefix(message.body, "Dear Meera,") || !strings.Contains(message.body, message.unsubscribeURL) {
+		t.Fatalf("unexpected email %+v", message)
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/notifications/unsubscribe", unsubscribeEmail)
+	for link, want := range map[string]int{
+		strings.Replace(message.unsubscribeURL, "reporter_1", "reporter_2", 1): http.StatusForbidden,
+		message.unsubscribeURL: http.StatusOK,
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, strings.TrimPrefix(link, emailer.publicURL), nil))
+		if w.Code != want {
+			t.Errorf("GET %s: expected %d, got %d", link, want, w.Code)
+		}
+	}
+
+	if err := emailer.process(ctx, event("tx3", "closed")); err != nil {
+		t.Fatal(err)
+	}
+	if len(mailer.sent) != 1 {
+		t.Errorf("expected no email after unsubscribing, got %d", len(mailer.sent))
+	}
+}
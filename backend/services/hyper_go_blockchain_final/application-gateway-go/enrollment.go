@@ -0,0 +1,520 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	enrollmentPrefix        = "enrollments/"
+	enrollmentReviewKey     = "sih:enrollments:review"
+	maxEnrollmentReviewList = 200
+	maxEnrollmentCSRLength  = 8 << 10
+
+	errCodeEnrollmentDisabled  = "ENROLLMENT_DISABLED"
+	errCodeEnrollmentDuplicate = "ENROLLMENT_DUPLICATE"
+	errCodeEnrollmentState     = "ENROLLMENT_INVALID_STATE"
+)
+
+// Enrollment request kinds, types and statuses
+const (
+	enrollmentDevice  = "device"
+	enrollmentOfficer = "officer"
+
+	enrollmentNew   = "enroll"
+	enrollmentRenew = "reenroll"
+
+	enrollmentPending  = "pending"
+	enrollmentIssued   = "issued"
+	enrollmentRejected = "rejected"
+)
+
+var (
+	enrollmentKinds = []string{enrollmentDevice, enrollmentOfficer}
+	enrollmentTypes = []string{enrollmentNew, enrollmentRenew}
+
+	errEnrollmentDuplicate = errors.New("the identity already has a pending enrollment request")
+	errEnrollmentState     = errors.New("the enrollment request is not pending")
+)
+
+// SubmitEnrollmentRequest asks for a certificate for a key the requester generated and keeps. Type
+// defaults to enroll. A re-enrollment proves the requester holds the identity's current key:
+// Signature is that key's ASN.1 ECDSA signature over the SHA-256 of the CSR's DER bytes, in base64.
+type SubmitEnrollmentRequest struct {
+	Kind         string `json:"kind" binding:"required"`
+	Type         string `json:"type"`
+	EnrollmentID string `json:"enrollmentId" binding:"required"`
+	Role         string `json:"role"`
+	CSR          string `json:"csr" binding:"required"`
+	Certificate  string `json:"certificate"`
+	Signature    string `json:"signature"`
+}
+
+func (r SubmitEnrollmentRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.oneOf("kind", r.Kind, enrollmentKinds)
+	if r.Type != "" {
+		v.oneOf("type", r.Type, enrollmentTypes)
+	}
+	if !officerUsernameRegex.MatchString(r.EnrollmentID) {
+		v.add("enrollmentId", "must be 1-64 characters of letters, digits, '.', '_' or '-'")
+	}
+	switch {
+	case r.Type == enrollmentRenew && r.Role != "":
+		v.add("role", "must be empty; a re-enrollment keeps the identity's attributes")
+	case r.Type != enrollmentRenew && r.Kind == enrollmentOfficer:
+		v.identifier("role", r.Role)
+	case r.Type != enrollmentRenew && r.Role != "":
+		v.add("role", "must be empty; devices get the configured device role")
+	}
+	if len(r.CSR) > maxEnrollmentCSRLength {
+		v.add("csr", "must not exceed %d bytes", maxEnrollmentCSRLength)
+	} else if csr, err := parseEnrollmentCSR(r.CSR); err != nil {
+		v.add("csr", "%s", err.Error())
+	} else if csr.Subject.CommonName != r.EnrollmentID {
+		v.add("csr", "common name must be the enrollment ID")
+	}
+	if r.Type == enrollmentRenew {
+		if r.Certificate == "" {
+			v.add("certificate", "is required to re-enroll")
+		}
+		if r.Signature == "" {
+			v.add("signature", "is required to re-enroll")
+		}
+	} else if r.Certificate != "" || r.Signature != "" {
+		v.add("certificate", "is only sent to re-enroll")
+	}
+	return v.errors
+}
+
+// parseEnrollmentCSR reads a PEM certificate request for a P-256 key and checks its self-signature
+func parseEnrollmentCSR(text string) (*x509.CertificateRequest, error) {
+	block, _ := pem.Decode([]byte(text))
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, errors.New("must be a PEM CERTIFICATE REQUEST")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, errors.New("is not a valid certificate request")
+	}
+	if key, ok := csr.PublicKey.(*ecdsa.PublicKey); !ok || key.Curve != elliptic.P256() {
+		return nil, errors.New("must be for an ECDSA P-256 key")
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, errors.New("signature does not verify")
+	}
+	return csr, nil
+}
+
+// EnrollmentReviewRequest approves or rejects a pending enrollment request
+type EnrollmentReviewRequest struct {
+	Reviewer string `json:"reviewer" binding:"required"`
+	Note     string `json:"note"`
+}
+
+func (r EnrollmentReviewRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.identifier("reviewer", r.Reviewer)
+	if len(r.Note) > 1000 {
+		v.add("note", "must not exceed 1000 characters")
+	}
+	return v.errors
+}
+
+// IdentityEnrollment is an enrollment request and, once approved, the certificate the CA issued
+type IdentityEnrollment struct {
+	RequestID    string `json:"request_id"`
+	Kind         string `json:"kind"`
+	Type         string `json:"type"`
+	EnrollmentID string `json:"enrollment_id"`
+	Role         string `json:"role,omitempty"`
+	CSR          string `json:"csr"`
+	// RequestedBy is the wallet identity the request was submitted as, empty for new devices
+	RequestedBy string `json:"requested_by,omitempty"`
+	Status      string `json:"status"`
+	Certificate string `json:"certificate,omitempty"`
+	Serial      string `json:"certificate_serial,omitempty"`
+	NotAfter    string `json:"certificate_expires_at,omitempty"`
+	ReviewedBy  string `json:"reviewed_by,omitempty"`
+	ReviewNote  string `json:"review_note,omitempty"`
+	ReviewedAt  string `json:"reviewed_at,omitempty"`
+	SubmittedAt string `json:"submitted_at"`
+	UpdatedAt   string `json:"updated_at"`
+}
+
+// enrollmentService queues certificate requests from devices and officers and, once a reviewer
+// approves them, has the Fabric CA issue them as the provisioning registrar
+type enrollmentService struct {
+	ca          *caClient
+	mspID       string
+	affiliation string
+	deviceRole  string
+	queue       reviewQueue
+	// mu serializes submissions and reviews, so an identity has at most one pending request
+	mu sync.Mutex
+}
+
+var enrollments *enrollmentService
+
+// initEnrollment runs after initProvisioning, whose CA registrar issues the certificates
+func initEnrollment() {
+	if !getEnvBool("ENROLLMENT_ENABLED", false) {
+		log.Println("📇 ENROLLMENT_ENABLED not set, identities are only enrolled by operators")
+		return
+	}
+	if provisioning == nil {
+		panic(fmt.Errorf("ENROLLMENT_ENABLED requires FABRIC_CA_URL"))
+	}
+	var queue reviewQueue = newMemoryReviewQueue()
+	backend := "memory"
+	if documentCache != nil {
+		queue, backend = redisReviewQueue{documentCache, enrollmentReviewKey}, "Redis"
+	}
+	enrollments = &enrollmentService{
+		ca:          provisioning.ca,
+		mspID:       provisioning.mspID,
+		affiliation: provisioning.affiliation,
+		deviceRole:  getEnv("ENROLLMENT_DEVICE_ROLE", "kiosk"),
+		queue:       queue,
+	}
+	log.Printf("📇 Self-service enrollment enabled, devices enroll as %s, review queue in %s", enrollments.deviceRole, backend)
+}
+
+func enrollmentKey(requestID string) string {
+	return enrollmentPrefix + requestID + ".json"
+}
+
+// enrollmentPendingKey holds the ID of an identity's pending request
+func enrollmentPendingKey(enrollmentID string) string {
+	return enrollmentPrefix + "pending/" + enrollmentID
+}
+
+func newEnrollmentRequestID(now time.Time) string {
+	suffix := make([]byte, 3)
+	rand.Read(suffix)
+	return fmt.Sprintf("ENR-%s-%s", now.UTC().Format("20060102T150405Z"), hex.EncodeToString(suffix))
+}
+
+// load reads a request from the evidence store, or nil when there is none
+func (e *enrollmentService) load(ctx context.Context, requestID string) (*IdentityEnrollment, error) {
+	body, err := evidenceStore.Get(ctx, enrollmentKey(requestID))
+	if errors.Is(err, errObjectNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	var enrollment IdentityEnrollment
+	if err := json.NewDecoder(body).Decode(&enrollment); err != nil {
+		return nil, &malformedDocumentError{kind: "enrollment request", err: err}
+	}
+	return &enrollment, nil
+}
+
+func (e *enrollmentService) save(ctx context.Context, enrollment *IdentityEnrollment) error {
+	enrollment.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	data, err := json.Marshal(enrollment)
+	if err != nil {
+		return err
+	}
+	return evidenceStore.Put(ctx, enrollmentKey(enrollment.RequestID), bytes.NewReader(data), int64(len(data)), "application/json")
+}
+
+// pendingFor returns the identity's pending request, if it has one
+func (e *enrollmentService) pendingFor(ctx context.Context, enrollmentID string) (*IdentityEnrollment, error) {
+	body, err := evidenceStore.Get(ctx, enrollmentPendingKey(enrollmentID))
+	if errors.Is(err, errObjectNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	id, err := io.ReadAll(io.LimitReader(body, 256))
+	if err != nil {
+		return nil, err
+	}
+	enrollment, err := e.load(ctx, string(id))
+	if err != nil || enrollment == nil || enrollment.Status != enrollmentPending {
+		return nil, err
+	}
+	return enrollment, nil
+}
+
+// verifyPossession checks a re-enrollment's current certificate was issued by the CA to the
+// identity and signed the new CSR
+func (e *enrollmentService) verifyPossession(ctx context.Context, req SubmitEnrollmentRequest) error {
+	invalid := func(message string) error {
+		return ValidationErrors{{Field: "certificate", Message: message}}
+	}
+	block, _ := pem.Decode([]byte(req.Certificate))
+	if block == nil {
+		return invalid("must be a PEM certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return invalid("is not a valid certificate")
+	}
+	if cert.Subject.CommonName != req.EnrollmentID {
+		return invalid("must be issued to the enrollment ID")
+	}
+	roots, err := e.ca.chain(ctx)
+	if err != nil {
+		return err
+	}
+	if _, err := cert.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}); err != nil {
+		return invalid("must be a current certificate issued by the CA")
+	}
+	key, ok := cert.PublicKey.(*ecdsa.PublicKey)
+	signature, err := base64.StdEncoding.DecodeString(req.Signature)
+	csrBlock, _ := pem.Decode([]byte(req.CSR))
+	digest := sha256.Sum256(csrBlock.Bytes)
+	if !ok || err != nil || !ecdsa.VerifyASN1(key, digest[:], signature) {
+		return ValidationErrors{{Field: "signature", Message: "must be the current key's signature over the CSR"}}
+	}
+	return nil
+}
+
+// submit queues a request for review. Only one request per identity can be pending.
+func (e *enrollmentService) submit(ctx context.Context, req SubmitEnrollmentRequest) (*IdentityEnrollment, error) {
+	if req.Type == enrollmentRenew {
+		if err := e.verifyPossession(ctx, req); err != nil {
+			return nil, err
+		}
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	existing, err := e.pendingFor(ctx, req.EnrollmentID)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return existing, errEnrollmentDuplicate
+	}
+
+	if req.Type == "" {
+		req.Type = enrollmentNew
+	}
+	now := time.Now().UTC()
+	enrollment := &IdentityEnrollment{
+		RequestID: newEnrollmentRequestID(now), Kind: req.Kind, Type: req.Type, EnrollmentID: req.EnrollmentID,
+		Role: req.Role, CSR: req.CSR, Status: enrollmentPending, SubmittedAt: now.Format(time.RFC3339),
+	}
+	if enrollment.Type == enrollmentNew && enrollment.Kind == enrollmentDevice {
+		enrollment.Role = e.deviceRole
+	}
+	if id, ok := ctx.Value(signerContextKey{}).(*walletIdentity); ok && id != nil {
+		enrollment.RequestedBy = id.Label
+	}
+	if err := e.save(ctx, enrollment); err != nil {
+		return nil, err
+	}
+	if err := evidenceStore.Put(ctx, enrollmentPendingKey(enrollment.EnrollmentID), bytes.NewReader([]byte(enrollment.RequestID)), int64(len(enrollment.RequestID)), "text/plain"); err != nil {
+		return nil, err
+	}
+	if err := e.queue.add(ctx, enrollment.RequestID, now); err != nil {
+		return nil, err
+	}
+	log.Printf("📇 Enrollment request %s queued: %s %s of %s", enrollment.RequestID, enrollment.Kind, enrollment.Type, enrollment.EnrollmentID)
+	return enrollment, nil
+}
+
+// review approves or rejects a pending request. Approving has the CA issue the certificate; if the
+// CA fails, the request stays pending and can be approved again.
+func (e *enrollmentService) review(ctx context.Context, requestID string, req EnrollmentReviewRequest, approve bool) (*IdentityEnrollment, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	enrollment, err := e.load(ctx, requestID)
+	if err != nil || enrollment == nil {
+		return nil, err
+	}
+	if enrollment.Status != enrollmentPending {
+		return enrollment, errEnrollmentState
+	}
+	if approve {
+		if err := e.issue(ctx, enrollment); err != nil {
+			return enrollment, err
+		}
+		enrollment.Status = enrollmentIssued
+	} else {
+		enrollment.Status = enrollmentRejected
+	}
+	enrollment.ReviewedBy, enrollment.ReviewNote, enrollment.ReviewedAt = req.Reviewer, req.Note, time.Now().UTC().Format(time.RFC3339)
+	if err := e.save(ctx, enrollment); err != nil {
+		return nil, err
+	}
+	if err := evidenceStore.Delete(ctx, enrollmentPendingKey(enrollment.EnrollmentID)); err != nil && !errors.Is(err, errObjectNotFound) {
+		return nil, err
+	}
+	log.Printf("📇 Enrollment request %s %s by %s", enrollment.RequestID, enrollment.Status, req.Reviewer)
+	return enrollment, e.queue.remove(ctx, enrollment.RequestID)
+}
+
+// issue registers a new identity with the request's role, or gives an existing one a fresh secret,
+// then enrolls the requester's CSR. The secret never leaves the gateway.
+func (e *enrollmentService) issue(ctx context.Context, enrollment *IdentityEnrollment) error {
+	secret := randomToken(24)
+	var attrs []caAttribute
+	if enrollment.Type == enrollmentNew {
+		if access != nil && !access.current().defines(enrollment.Role) {
+			return ValidationErrors{{Field: "role", Message: fmt.Sprintf("%s is not a role in the access policy", enrollment.Role)}}
+		}
+		attrs = []caAttribute{
+			{Name: caRoleAttr, Value: enrollment.Role, ECert: true},
+			{Name: caOrgAttr, Value: e.mspID, ECert: true},
+			{Name: caAttrPrefix + "kind", Value: enrollment.Kind, ECert: true},
+		}
+		reg := caRegistration{ID: enrollment.EnrollmentID, Type: "client", Secret: secret, MaxEnrollments: 1, Affiliation: e.affiliation, Attributes: attrs}
+		if err := e.ca.register(ctx, reg); err != nil {
+			return err
+		}
+	} else if err := e.ca.modify(ctx, enrollment.EnrollmentID, secret); err != nil {
+		return err
+	}
+
+	cert, certPEM, err := e.ca.issue(ctx, enrollment.EnrollmentID, secret, []byte(enrollment.CSR), attrs)
+	if err != nil {
+		if enrollment.Type == enrollmentNew {
+			if _, revokeErr := e.ca.revoke(context.WithoutCancel(ctx), enrollment.EnrollmentID, "cessationofoperation"); revokeErr != nil {
+				log.Printf("⚠️  Enrollment %s failed and its CA registration could not be revoked: %v", enrollment.RequestID, revokeErr)
+			}
+		}
+		return err
+	}
+	enrollment.Certificate = string(certPEM)
+	enrollment.Serial = cert.SerialNumber.Text(16)
+	enrollment.NotAfter = cert.NotAfter.UTC().Format(time.RFC3339)
+	return nil
+}
+
+// pending lists the review queue, oldest first
+func (e *enrollmentService) pending(ctx context.Context) ([]IdentityEnrollment, error) {
+	ids, err := e.queue.list(ctx, maxEnrollmentReviewList)
+	if err != nil {
+		return nil, err
+	}
+	requests := make([]IdentityEnrollment, 0, len(ids))
+	for _, id := range ids {
+		enrollment, err := e.load(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if enrollment != nil {
+			requests = append(requests, *enrollment)
+		}
+	}
+	return requests, nil
+}
+
+func enrollmentDisabled(c *gin.Context) bool {
+	if enrollments == nil {
+		respondError(c, http.StatusServiceUnavailable, errCodeEnrollmentDisabled, "Self-service enrollment is disabled; set ENROLLMENT_ENABLED")
+		return true
+	}
+	return false
+}
+
+// respondEnrollment answers with the request: 202 while it waits for review
+func respondEnrollment(c *gin.Context, action string, enrollment *IdentityEnrollment, err error) {
+	var caErr *caError
+	switch {
+	case errors.Is(err, errEnrollmentDuplicate):
+		respondError(c, http.StatusConflict, errCodeEnrollmentDuplicate, fmt.Sprintf("%s already has pending request %s", enrollment.EnrollmentID, enrollment.RequestID))
+	case errors.Is(err, errEnrollmentState):
+		respondError(c, http.StatusConflict, errCodeEnrollmentState, fmt.Sprintf("Enrollment request %s is %s, not pending", enrollment.RequestID, enrollment.Status))
+	case errors.As(err, &caErr):
+		logWithContext(c.Request.Context(), "%s: %v", action, err)
+		respondError(c, http.StatusBadGateway, errCodeCARequestFailed, caErr.Error())
+	case err != nil:
+		respondServiceError(c, action, err)
+	case enrollment == nil:
+		respondError(c, http.StatusNotFound, errCodeNotFound, "Enrollment request not found")
+	case enrollment.Status == enrollmentPending:
+		respondData(c, http.StatusAccepted, enrollment)
+	default:
+		respondData(c, http.StatusOK, enrollment)
+	}
+}
+
+func submitEnrollment(c *gin.Context) {
+	if enrollmentDisabled(c) {
+		return
+	}
+	var req SubmitEnrollmentRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+	enrollment, err := enrollments.submit(c.Request.Context(), req)
+	if enrollment != nil {
+		setAuditTarget(c, enrollment.RequestID)
+	}
+	respondEnrollment(c, "Failed to submit enrollment request", enrollment, err)
+}
+
+func getEnrollment(c *gin.Context) {
+	if enrollmentDisabled(c) {
+		return
+	}
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	enrollment, err := enrollments.load(c.Request.Context(), id)
+	respondEnrollment(c, "Failed to read enrollment request", enrollment, err)
+}
+
+func listEnrollmentReviewQueue(c *gin.Context) {
+	if enrollmentDisabled(c) {
+		return
+	}
+	requests, err := enrollments.pending(c.Request.Context())
+	if err != nil {
+		respondServiceError(c, "Failed to read the enrollment review queue", err)
+		return
+	}
+	respondData(c, http.StatusOK, requests)
+}
+
+func reviewEnrollment(approve bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if enrollmentDisabled(c) {
+			return
+		}
+		id, ok := validPathID(c, "id")
+		if !ok {
+			return
+		}
+		var req EnrollmentReviewRequest
+		if !bindRequest(c, &req) {
+			return
+		}
+		setAuditTarget(c, id)
+		enrollment, err := enrollments.review(c.Request.Context(), id, req, approve)
+		respondEnrollment(c, "Failed to review enrollment request", enrollment, err)
+	}
+}
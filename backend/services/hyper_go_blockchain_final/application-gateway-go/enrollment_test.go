@@ -0,0 +1,138 @@
+package gateway
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"testing"
+)
+
+// newEnrollmentCSR returns a key and a PEM CSR for it with the enrollment ID as common name
+func newEnrollmentCSR(t *testing.T, enrollmentID string) (*ecdsa.PrivateKey, string) {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: enrollmentID}}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}))
+}
+
+func TestEnrollmentReview(t *testing.T) {
+	store, err := newLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	previousStore, previousAccess := evidenceStore, access
+	evidenceStore, access = store, nil
+	defer func() { evidenceStore, access = previousStore, previousAccess }()
+
+	ca, client := newFakeCA(t)
+	e := &enrollmentService{ca: client, mspID: "Org1MSP", deviceRole: "kiosk", queue: newMemoryReviewQueue()}
+	ctx := context.Background()
+	approval := EnrollmentReviewRequest{Reviewer: "sp_kamrup"}
+
+	oldKey, csr := newEnrollmentCSR(t, "kiosk-shillong-01")
+	submitted, err := e.submit(ctx, SubmitEnrollmentRequest{Kind: enrollmentDevice, EnrollmentID: "kiosk-shillong-01", CSR: csr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if submitted.Status != enrollmentPending || submitted.Type != enrollmentNew || submitted.Role != "kiosk" {
+		t.Errorf("unexpected request %+v", submitted)
+	}
+	if _, err := e.submit(ctx, SubmitEnrollmentRequest{Kind: enrollmentDevice, EnrollmentID: "kiosk-shillong-01", CSR: csr}); !errors.Is(err, errEnrollmentDuplicate) {
+		t.Errorf("expected a second pending request refused, got %v", err)
+	}
+	if queued, _ := e.pending(ctx); len(queued) != 1 {
+		t.Errorf("expected one request awaiting review, got %+v", queued)
+	}
+
+	issued, err := e.review(ctx, submitted.RequestID, approval, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode([]byte(issued.Certificate))
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil || issued.Status != enrollmentIssued || issued.ReviewedBy != "sp_kamrup" {
+		t.Fatalf("expected the certificate issued, got %+v %v", issued, err)
+	}
+	if attrs, _ := certificateAttributes(cert); attrs[caRoleAttr] != "kiosk" || attrs[caAttrPrefix+"kind"] != enrollmentDevice {
+		t.Errorf("expected the device role on the certificate, got %v", attrs)
+	}
+	if !cert.PublicKey.(*ecdsa.PublicKey).Equal(&oldKey.PublicKey) {
+		t.Error("expected the certificate for the requester's own key")
+	}
+	if queued, _ := e.pending(ctx); len(queued) != 0 {
+		t.Errorf("expected the review queue empty, got %+v", queued)
+	}
+	if _, err := e.review(ctx, submitted.RequestID, approval, false); !errors.Is(err, errEnrollmentState) {
+		t.Errorf("expected an issued request not reviewed again, got %v", err)
+	}
+
+	// Renewal needs the current key's signature over the new CSR
+	newKey, renewalCSR := newEnrollmentCSR(t, "kiosk-shillong-01")
+	csrBlock, _ := pem.Decode([]byte(renewalCSR))
+	digest := sha256.Sum256(csrBlock.Bytes)
+	forged, _ := ecdsa.SignASN1(rand.Reader, newKey, digest[:])
+	renewal := SubmitEnrollmentRequest{Kind: enrollmentDevice, Type: enrollmentRenew, EnrollmentID: "kiosk-shillong-01", CSR: renewalCSR, Certificate: issued.Certificate, Signature: base64.StdEncoding.EncodeToString(forged)}
+	var validationErrs ValidationErrors
+	if _, err := e.submit(ctx, renewal); !errors.As(err, &validationErrs) || validationErrs[0].Field != "signature" {
+		t.Errorf("expected a renewal signed by the new key refused, got %v", err)
+	}
+	signature, _ := ecdsa.SignASN1(rand.Reader, oldKey, digest[:])
+	renewal.Signature = base64.StdEncoding.EncodeToString(signature)
+	pending, err := e.submit(ctx, renewal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := ca.registered["kiosk-shillong-01"].Secret
+	renewed, err := e.review(ctx, pending.RequestID, approval, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renewed.Status != enrollmentIssued || renewed.Certificate == issued.Certificate || ca.registered["kiosk-shillong-01"].Secret == secret {
+		t.Errorf("expected a new certificate enrolled with a fresh secret, got %+v", renewed)
+	}
+
+	// A rejected request never reaches the CA
+	_, officerCSR := newEnrollmentCSR(t, "officer-anu")
+	request, err := e.submit(ctx, SubmitEnrollmentRequest{Kind: enrollmentOfficer, EnrollmentID: "officer-anu", Role: "officer", CSR: officerCSR})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rejected, err := e.review(ctx, request.RequestID, EnrollmentReviewRequest{Reviewer: "sp_kamrup", Note: "Not on the duty roster"}, false)
+	if err != nil || rejected.Status != enrollmentRejected {
+		t.Errorf("expected the request rejected, got %+v %v", rejected, err)
+	}
+	if _, registered := ca.registered["officer-anu"]; registered {
+		t.Error("expected no CA registration for a rejected request")
+	}
+}
+
+func TestSubmitEnrollmentValidation(t *testing.T) {
+	_, csr := newEnrollmentCSR(t, "kiosk-01")
+	cases := []struct {
+		req   SubmitEnrollmentRequest
+		field string
+	}{
+		{SubmitEnrollmentRequest{Kind: "printer", EnrollmentID: "kiosk-01", CSR: csr}, "kind"},
+		{SubmitEnrollmentRequest{Kind: enrollmentDevice, EnrollmentID: "kiosk-01", Role: "admin", CSR: csr}, "role"},
+		{SubmitEnrollmentRequest{Kind: enrollmentOfficer, EnrollmentID: "kiosk-01", CSR: csr}, "role"},
+		{SubmitEnrollmentRequest{Kind: enrollmentDevice, EnrollmentID: "kiosk-02", CSR: csr}, "csr"},
+		{SubmitEnrollmentRequest{Kind: enrollmentDevice, EnrollmentID: "kiosk-01", CSR: "not a csr"}, "csr"},
+		{SubmitEnrollmentRequest{Kind: enrollmentDevice, EnrollmentID: "kiosk-01", CSR: csr, Signature: "c2ln"}, "certificate"},
+		{SubmitEnrollmentRequest{Kind: enrollmentDevice, Type: enrollmentRenew, EnrollmentID: "kiosk-01", CSR: csr, Certificate: "cert"}, "signature"},
+	}
+	for _, tc := range cases {
+		if errs := tc.req.Validate(); len(errs) != 1 || errs[0].Field != tc.field {
+			t.Errorf("expected %s rejected, got %v", tc.field, errs)
+		}
+	}
+}
@@ -0,0 +1,527 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	erasureRequest   = "request"
+	erasureRetention = "retention"
+
+	verificationDocument = "document"
+	verificationOfficer  = "officer"
+	verificationWindow   = "retention_window"
+
+	erasureCertificateType = "erasure-certificate+jwt"
+
+	errCodeErasureUnverified = "ERASURE_NOT_VERIFIED"
+)
+
+var (
+	erasureVerifications = []string{verificationDocument, verificationOfficer}
+
+	errErasureUnverified = errors.New("the identity document does not match the DID")
+)
+
+// ErasureEntry is how many items of one category of personal data were purged
+type ErasureEntry struct {
+	Category string `json:"category"`
+	Purged   int    `json:"purged"`
+}
+
+// ErasureRecord is the ledger's proof of an erasure, as the chaincode stores it
+type ErasureRecord struct {
+	ErasureID    string         `json:"erasure_id"`
+	DigitalID    string         `json:"digital_id"`
+	Reason       string         `json:"reason"`
+	Verification string         `json:"verification"`
+	VerifiedBy   string         `json:"verified_by,omitempty"`
+	Manifest     []ErasureEntry `json:"manifest"`
+	ManifestHash string         `json:"manifest_hash"`
+	ErasedBy     string         `json:"erased_by"`
+	ErasedAt     string         `json:"erased_at"`
+	TxID         string         `json:"tx_id"`
+}
+
+// ErasureRequest asks for a tourist's off-chain personal data to be erased. With document
+// verification the tourist proves who they are with the identity document their DID was issued
+// from; with officer verification an officer who checked their identity in person is named.
+type ErasureRequest struct {
+	DigitalID      string `json:"digitalID" binding:"required"`
+	Verification   string `json:"verification" binding:"required"`
+	DocumentType   string `json:"documentType"`
+	DocumentNumber string `json:"documentNumber"`
+	Nationality    string `json:"nationality"`
+	VerifiedBy     string `json:"verifiedBy"`
+	RequestedBy    string `json:"requestedBy" binding:"required"`
+}
+
+func (r ErasureRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.digitalID("digitalID", r.DigitalID)
+	v.identifier("requestedBy", r.RequestedBy)
+	v.oneOf("verification", r.Verification, erasureVerifications)
+	switch r.Verification {
+	case verificationDocument:
+		v.oneOf("documentType", r.DocumentType, kycDocumentTypes)
+		if r.DocumentNumber == "" {
+			v.add("documentNumber", "is required for document verification")
+		}
+	case verificationOfficer:
+		v.identifier("verifiedBy", r.VerifiedBy)
+	}
+	return v.errors
+}
+
+// ErasureListRequest names the DID whose erasures to list
+type ErasureListRequest struct {
+	DigitalID string `form:"digitalID" binding:"required"`
+}
+
+func (r ErasureListRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.digitalID("digitalID", r.DigitalID)
+	return v.errors
+}
+
+// ErasureResult is an erasure with its signed certificate
+type ErasureResult struct {
+	Erasure     ErasureRecord `json:"erasure"`
+	Certificate string        `json:"certificate"`
+}
+
+// VerifyErasureCertificateRequest carries a certificate presented as proof of erasure
+type VerifyErasureCertificateRequest struct {
+	Certificate string `json:"certificate" binding:"required"`
+}
+
+func (r VerifyErasureCertificateRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	if len(r.Certificate) > maxCredentialToken {
+		v.add("certificate", "must be at most %d characters", maxCredentialToken)
+	}
+	return v.errors
+}
+
+// ErasureCertificateVerification is the verdict on a certificate. Valid requires the signature to
+// verify and the erasure it names to be on the ledger with the same manifest.
+type ErasureCertificateVerification struct {
+	Valid          bool           `json:"valid"`
+	SignatureValid bool           `json:"signature_valid"`
+	Reason         string         `json:"reason,omitempty"`
+	Erasure        *ErasureRecord `json:"erasure,omitempty"`
+}
+
+// erasureCategory is one kind of off-chain personal data, with how to erase a tourist's share of it
+type erasureCategory struct {
+	name  string
+	erase func(ctx context.Context, digitalID string) (int, error)
+}
+
+// erasureService purges a tourist's off-chain personal data, records an erasure proof on the ledger
+// and signs a certificate of it. With RETENTION_WINDOW set it also erases, on its own, the data of
+// every DID that expired longer ago than the window. Evidence and incidents are kept: they are
+// records of the case, not of the tourist.
+type erasureService struct {
+	categories []erasureCategory
+	signer     *qrSigner
+	issuer     string
+	window     time.Duration
+	interval   time.Duration
+	actor      string
+
+	mu   sync.Mutex
+	done map[string]bool
+}
+
+var eraser *erasureService
+
+// initErasure runs after initKYC, initContacts, initLocation and initPush, whose data it erases
+func initErasure() {
+	eraser = &erasureService{
+		issuer:   getEnv("ERASURE_ISSUER", "did:sih:gateway"),
+		window:   getEnvDuration("RETENTION_WINDOW", 0),
+		interval: getEnvDuration("RETENTION_INTERVAL", 24*time.Hour),
+		actor:    getEnv("RETENTION_ACTOR", "retention-engine"),
+		done:     map[string]bool{},
+	}
+	if eraser.window < 0 || eraser.interval <= 0 {
+		panic(fmt.Errorf("RETENTION_WINDOW must not be negative and RETENTION_INTERVAL must be positive"))
+	}
+	eraser.categories = erasureCategories()
+
+	if path := getEnv("ERASURE_SIGNING_KEY_FILE", ""); path != "" {
+		signer, err := loadQRSigner(path)
+		if err != nil {
+			panic(fmt.Errorf("failed to load erasure signing key: %w", err))
+		}
+		eraser.signer = signer
+	} else {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			panic(fmt.Errorf("failed to generate erasure signing key: %w", err))
+		}
+		eraser.signer = newQRSigner(key)
+		log.Printf("⚠️  ERASURE_SIGNING_KEY_FILE not set; erasure certificates are signed with an ephemeral key (%s)", eraser.signer.keyID)
+	}
+
+	names := make([]string, len(eraser.categories))
+	for i, category := range eraser.categories {
+		names[i] = category.name
+	}
+	if eraser.window == 0 {
+		log.Printf("🧹 RETENTION_WINDOW not set; %s are only erased on request", strings.Join(names, ", "))
+		return
+	}
+	log.Printf("🧹 Erasing %s %s after a DID expires", strings.Join(names, ", "), eraser.window)
+}
+
+// erasureCategories lists the personal data the enabled services keep off-chain
+func erasureCategories() []erasureCategory {
+	var categories []erasureCategory
+	if onboarding != nil {
+		categories = append(categories, erasureCategory{"kyc", onboarding.erase})
+	}
+	if contactsRegistry != nil {
+		categories = append(categories, erasureCategory{"emergency_contacts", func(ctx context.Context, digitalID string) (int, error) {
+			registered, err := contactsRegistry.store.list(ctx, digitalID)
+			if err != nil {
+				return 0, err
+			}
+			for i, contact := range registered {
+				if err := contactsRegistry.store.remove(ctx, digitalID, contact.ContactID); err != nil {
+					return i, err
+				}
+			}
+			return len(registered), nil
+		}})
+	}
+	if locations != nil {
+		categories = append(categories, erasureCategory{"location", func(ctx context.Context, digitalID string) (int, error) {
+			return locations.store.remove(ctx, digitalID)
+		}})
+	}
+	if pusher != nil {
+		categories = append(categories, erasureCategory{"push_devices", func(ctx context.Context, digitalID string) (int, error) {
+			devices, err := pusher.store.list(ctx)
+			if err != nil {
+				return 0, err
+			}
+			removed := 0
+			for _, device := range devices {
+				if device.DigitalID != digitalID {
+					continue
+				}
+				if err := pusher.store.remove(ctx, device.Token); err != nil {
+					return removed, err
+				}
+				removed++
+			}
+			return removed, nil
+		}})
+	}
+	if broadcasts != nil {
+		categories = append(categories, erasureCategory{"broadcast_opt_out", func(ctx context.Context, digitalID string) (int, error) {
+			changed, err := broadcasts.optOuts.set(ctx, digitalID, false)
+			if changed {
+				return 1, err
+			}
+			return 0, err
+		}})
+	}
+	return categories
+}
+
+// verify checks an erasure request's proof of identity. A DID issued through KYC is derived from
+// the identity document, so presenting the document proves the tourist holds it.
+func (e *erasureService) verify(req ErasureRequest) error {
+	if req.Verification != verificationDocument {
+		return nil
+	}
+	if onboarding == nil {
+		return errErasureUnverified
+	}
+	identity := KYCApplicationRequest{DocumentType: req.DocumentType, DocumentNumber: req.DocumentNumber, Nationality: req.Nationality}
+	identity.normalize()
+	derived := onboarding.digitalID(onboarding.identifierHash(identity))
+	if subtle.ConstantTimeCompare([]byte(derived), []byte(req.DigitalID)) != 1 {
+		return errErasureUnverified
+	}
+	return nil
+}
+
+// request erases a tourist's data on their verified request
+func (e *erasureService) request(ctx context.Context, req ErasureRequest) (*ErasureResult, error) {
+	if errs := req.Validate(); len(errs) > 0 {
+		return nil, errs
+	}
+	if err := e.verify(req); err != nil {
+		return nil, err
+	}
+	if _, err := ledger.GetDID(ctx, req.DigitalID); err != nil {
+		return nil, err
+	}
+	verifiedBy := req.VerifiedBy
+	if req.Verification == verificationDocument {
+		verifiedBy = ""
+	}
+	return e.erase(ctx, "ERASURE:"+newUUID(), req.DigitalID, erasureRequest, req.Verification, verifiedBy, req.RequestedBy)
+}
+
+// erase purges every category, then records the manifest on the ledger. A category that fails
+// stops the erasure before anything is recorded; erasing again finishes it, as purging is
+// idempotent.
+func (e *erasureService) erase(ctx context.Context, erasureID, digitalID, reason, verification, verifiedBy, actor string) (*ErasureResult, error) {
+	manifest := make([]ErasureEntry, 0, len(e.categories))
+	for _, category := range e.categories {
+		purged, err := category.erase(ctx, digitalID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to erase %s of %s: %w", category.name, digitalID, err)
+		}
+		manifest = append(manifest, ErasureEntry{Category: category.name, Purged: purged})
+	}
+	if len(manifest) == 0 {
+		// The chaincode requires a manifest, and the proof should say there was nothing to erase
+		manifest = append(manifest, ErasureEntry{Category: "none"})
+	}
+	manifestJSON, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+	result, err := submitTransaction(ctx, "RecordErasure", erasureID, digitalID, reason, verification, verifiedBy, string(manifestJSON), actor)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(manifestJSON)
+	record := ErasureRecord{
+		ErasureID:    erasureID,
+		DigitalID:    digitalID,
+		Reason:       reason,
+		Verification: verification,
+		VerifiedBy:   verifiedBy,
+		Manifest:     manifest,
+		ManifestHash: hex.EncodeToString(sum[:]),
+		ErasedBy:     actor,
+		ErasedAt:     time.Now().UTC().Format(time.RFC3339),
+		TxID:         result.TxID,
+	}
+	certificate, err := e.certificate(record)
+	if err != nil {
+		return nil, err
+	}
+	logWithContext(ctx, "🧹 Erased the personal data of %s (%s) as %s", digitalID, reason, erasureID)
+	return &ErasureResult{Erasure: record, Certificate: certificate}, nil
+}
+
+// certificate signs an erasure record as a compact JWS. Ed25519 signatures are deterministic, so a
+// certificate issued again for the same record is identical.
+func (e *erasureService) certificate(record ErasureRecord) (string, error) {
+	erasedAt, _ := time.Parse(time.RFC3339, record.ErasedAt)
+	return e.signer.signJWT(erasureCertificateType, e.verificationMethod(), map[string]interface{}{
+		"iss":     e.issuer,
+		"sub":     record.DigitalID,
+		"jti":     record.ErasureID,
+		"iat":     erasedAt.Unix(),
+		"erasure": record,
+	})
+}
+
+func (e *erasureService) verificationMethod() string {
+	return e.issuer + "#" + e.signer.keyID
+}
+
+// verifyCertificate checks a certificate's signature, then that the ledger holds the erasure it
+// names with the same manifest
+func (e *erasureService) verifyCertificate(ctx context.Context, certificate string) (ErasureCertificateVerification, error) {
+	claims, err := e.signer.verifyJWT(strings.TrimSpace(certificate), e.verificationMethod())
+	if err != nil {
+		return ErasureCertificateVerification{Reason: err.Error()}, nil
+	}
+	verdict := ErasureCertificateVerification{SignatureValid: true}
+	erasureID, _ := claims["jti"].(string)
+	claimed, _ := claims["erasure"].(map[string]interface{})
+	if erasureID == "" || claimed == nil {
+		verdict.Reason = errCredentialMalformed.Error()
+		return verdict, nil
+	}
+	record, err := ledger.ReadErasure(ctx, erasureID)
+	if err != nil {
+		if translateFabricError(err).Code == errCodeNotFound {
+			verdict.Reason = "not_on_ledger"
+			return verdict, nil
+		}
+		return verdict, err
+	}
+	verdict.Erasure = &record
+	if claimed["manifest_hash"] != record.ManifestHash || claims["sub"] != record.DigitalID {
+		verdict.Reason = "ledger_mismatch"
+		return verdict, nil
+	}
+	verdict.Valid = true
+	return verdict, nil
+}
+
+// sweep erases the data of DIDs that expired longer than RETENTION_WINDOW ago; the scheduler runs
+// it every RETENTION_INTERVAL. Each DID's retention erasure has a fixed ID, so it is only ever
+// recorded once.
+func (e *erasureService) sweep(ctx context.Context, now time.Time) error {
+	expired, err := exportedInPeriod(ctx, "did", time.Time{}, now.Add(-e.window), func(d DIDDocument) string { return d.ExpiresAt })
+	if err != nil {
+		return err
+	}
+	erased, failed := 0, 0
+	for _, did := range expired {
+		id := "ERASURE:retention:" + did.DigitalID
+		e.mu.Lock()
+		done := e.done[id]
+		e.mu.Unlock()
+		if done {
+			continue
+		}
+		if _, err := ledger.ReadErasure(ctx, id); err == nil {
+			e.markDone(id)
+			continue
+		}
+		if claimed, err := claimEvent(ctx, "erasure:"+id, time.Hour); err != nil || !claimed {
+			continue
+		}
+		if _, err := e.erase(ctx, id, did.DigitalID, erasureRetention, verificationWindow, "", e.actor); err != nil {
+			if translateFabricError(err).Code == errCodeAlreadyExists {
+				e.markDone(id)
+			} else {
+				log.Printf("🧹 Failed to erase expired DID %s: %v", did.DigitalID, err)
+				failed++
+			}
+			continue
+		}
+		e.markDone(id)
+		erased++
+	}
+	if erased > 0 {
+		log.Printf("🧹 Retention sweep erased the personal data of %d expired DIDs", erased)
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to erase %d expired DIDs", failed)
+	}
+	return nil
+}
+
+func (e *erasureService) markDone(id string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.done[id] = true
+}
+
+// ReadErasure returns an erasure record
+func (s ledgerService) ReadErasure(ctx context.Context, erasureID string) (ErasureRecord, error) {
+	result, err := s.readDocument(ctx, "ReadErasure", erasureID)
+	if err != nil {
+		return ErasureRecord{}, err
+	}
+	return decodeDocument[ErasureRecord](result, "erasure")
+}
+
+// Erasures lists the erasures recorded for a DID
+func (ledgerService) Erasures(ctx context.Context, req ErasureListRequest) ([]ErasureRecord, error) {
+	if errs := req.Validate(); len(errs) > 0 {
+		return nil, errs
+	}
+	result, err := evaluateTransaction(ctx, "GetErasures", req.DigitalID)
+	if err != nil {
+		return nil, err
+	}
+	return decodeDocument[[]ErasureRecord](result, "erasure")
+}
+
+func requestErasure(c *gin.Context) {
+	var req ErasureRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+	setAuditTarget(c, req.DigitalID)
+
+	result, err := eraser.request(c.Request.Context(), req)
+	if errors.Is(err, errErasureUnverified) {
+		respondError(c, http.StatusForbidden, errCodeErasureUnverified, "The identity document does not match the DID")
+		return
+	}
+	if err != nil {
+		respondServiceError(c, "Failed to erase personal data", err)
+		return
+	}
+	respondCommitted(c, http.StatusCreated, result, &TransactionResult{TxID: result.Erasure.TxID})
+}
+
+func listErasures(c *gin.Context) {
+	var req ErasureListRequest
+	if !bindQuery(c, &req) {
+		return
+	}
+	erasures, err := ledger.Erasures(c.Request.Context(), req)
+	if err != nil {
+		respondServiceError(c, "Failed to list erasures", err)
+		return
+	}
+	respondData(c, http.StatusOK, erasures)
+}
+
+// getErasureCertificate reissues the certificate of an erasure recorded on the ledger
+func getErasureCertificate(c *gin.Context) {
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	record, err := ledger.ReadErasure(c.Request.Context(), id)
+	if err != nil {
+		respondServiceError(c, "Failed to read erasure", err)
+		return
+	}
+	certificate, err := eraser.certificate(record)
+	if err != nil {
+		respondServiceError(c, "Failed to sign erasure certificate", err)
+		return
+	}
+	respondData(c, http.StatusOK, ErasureResult{Erasure: record, Certificate: certificate})
+}
+
+// getErasureKeys publishes the certificate signing key as a JWK Set, for offline verification
+func getErasureKeys(c *gin.Context) {
+	c.Header("Cache-Control", "max-age=300")
+	c.JSON(http.StatusOK, gin.H{"keys": []gin.H{eraser.signer.publicJWK(eraser.verificationMethod())}})
+}
+
+// verifyErasureCertificate checks a certificate presented as proof of erasure
+func verifyErasureCertificate(c *gin.Context) {
+	var req VerifyErasureCertificateRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+	verdict, err := eraser.verifyCertificate(c.Request.Context(), req.Certificate)
+	if err != nil {
+		respondServiceError(c, "Failed to verify erasure certificate", err)
+		return
+	}
+	respondData(c, http.StatusOK, verdict)
+}
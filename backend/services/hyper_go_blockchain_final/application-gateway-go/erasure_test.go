@@ -0,0 +1,105 @@
+package gateway
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestErasureCategories(t *testing.T) {
+	store, err := newLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	previousStore, previousKYC, previousLocations := evidenceStore, onboarding, locations
+	evidenceStore = store
+	onboarding = &kycService{pepper: []byte("0123456789abcdef"), issuer: "kyc-onboarding", didMethod: "sih", verifier: &unverifiedKYC{}, queue: newMemoryReviewQueue()}
+	locations = &locationService{store: newMemoryLocationStore(time.Hour)}
+	defer func() { evidenceStore, onboarding, locations = previousStore, previousKYC, previousLocations }()
+	ctx := context.Background()
+
+	app, _, err := onboarding.submit(ctx, KYCApplicationRequest{
+		DocumentType: "aadhaar", DocumentNumber: "234123412346", Nationality: "IN", FullName: "Asha Devi", DateOfBirth: "1990-04-01",
+		ExpiresAt: time.Now().Add(time.Hour).UTC().Format(time.RFC3339), Consent: KYCConsent{Purposes: []string{"location_tracking"}, PolicyVersion: "v1"},
+	}, []kycUpload{{name: "selfie", mediaType: "image/png", data: []byte("\x89PNG\r\n\x1a\nselfie")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	locations.store.update(ctx, LiveLocation{DigitalID: app.DigitalID, RecordedAt: now, ReceivedAt: now})
+	locations.store.appendPending(ctx, app.DigitalID, []string{"a", "b"})
+
+	e := &erasureService{categories: erasureCategories()}
+	if len(e.categories) != 2 || e.categories[0].name != "kyc" || e.categories[1].name != "location" {
+		t.Fatalf("expected the KYC and location categories, got %+v", e.categories)
+	}
+
+	// The DID is derived from the identity document, however the number is typed
+	req := ErasureRequest{DigitalID: app.DigitalID, Verification: verificationDocument, DocumentType: "aadhaar", DocumentNumber: "2341 2341 2346", RequestedBy: "tourist"}
+	if errs := req.Validate(); len(errs) > 0 {
+		t.Fatalf("unexpected validation errors %v", errs)
+	}
+	if err := e.verify(req); err != nil {
+		t.Errorf("expected the document to verify, got %v", err)
+	}
+	req.DocumentNumber = "234123412353"
+	if err := e.verify(req); !errors.Is(err, errErasureUnverified) {
+		t.Errorf("expected another document to be refused, got %v", err)
+	}
+	if errs := (ErasureRequest{DigitalID: app.DigitalID, Verification: verificationOfficer, RequestedBy: "tourist"}).Validate(); len(errs) != 1 || errs[0].Field != "verifiedBy" {
+		t.Errorf("expected officer verification to name the officer, got %v", errs)
+	}
+
+	for i, want := range []int{2, 3} {
+		if purged, err := e.categories[i].erase(ctx, app.DigitalID); err != nil || purged != want {
+			t.Errorf("expected %s to purge %d items, got %d, %v", e.categories[i].name, want, purged, err)
+		}
+		if purged, _ := e.categories[i].erase(ctx, app.DigitalID); purged != 0 {
+			t.Errorf("expected erasing %s again to purge nothing, got %d", e.categories[i].name, purged)
+		}
+	}
+	erased, err := onboarding.load(ctx, app.ApplicationID)
+	if err != nil || erased.FullName != "" || erased.DateOfBirth != "" || erased.ErasedAt == "" || erased.IdentifierHash != app.IdentifierHash {
+		t.Errorf("expected the personal details blanked and the keyed hash kept, got %+v", erased)
+	}
+	if _, err := evidenceStore.Get(ctx, kycArtifactKey(app.ApplicationID, "selfie")); !errors.Is(err, errObjectNotFound) {
+		t.Errorf("expected the artifact deleted, got %v", err)
+	}
+}
+
+func TestErasureCertificate(t *testing.T) {
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	e := &erasureService{signer: newQRSigner(key), issuer: "did:sih:gateway"}
+	record := ErasureRecord{
+		ErasureID: "ERASURE:1", DigitalID: "did:sih:tourist_1", Reason: erasureRequest, Verification: verificationOfficer, VerifiedBy: "officer_7",
+		Manifest: []ErasureEntry{{Category: "kyc", Purged: 2}}, ManifestHash: "abc", ErasedAt: "2026-07-06T10:00:00Z", TxID: "tx1",
+	}
+	certificate, err := e.certificate(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := e.certificate(record); again != certificate {
+		t.Error("expected the certificate of a record to be reissued identically")
+	}
+	claims, err := e.signer.verifyJWT(certificate, e.verificationMethod())
+	if err != nil || claims["sub"] != record.DigitalID || claims["jti"] != record.ErasureID {
+		t.Fatalf("expected the certificate to verify, got %v, %v", claims, err)
+	}
+	if erasure, _ := claims["erasure"].(map[string]interface{}); erasure["manifest_hash"] != "abc" {
+		t.Errorf("expected the manifest hash in the certificate, got %v", erasure)
+	}
+
+	parts := strings.Split(certificate, ".")
+	record.Manifest[0].Purged = 0
+	forged, _ := e.certificate(record)
+	if _, err := e.signer.verifyJWT(parts[0]+"."+strings.Split(forged, ".")[1]+"."+parts[2], e.verificationMethod()); !errors.Is(err, errCredentialBadSignature) {
+		t.Errorf("expected a changed manifest to break the signature, got %v", err)
+	}
+	if verdict, err := e.verifyCertificate(context.Background(), "not-a-certificate"); err != nil || verdict.Valid || verdict.Reason != "malformed" {
+		t.Errorf("expected a malformed verdict, got %+v, %v", verdict, err)
+	}
+}
@@ -0,0 +1,168 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	gatewaypb "github.com/hyperledger/fabric-protos-go-apiv2/gateway"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Error codes produced by translating Fabric failures
+const (
+	errCodeAlreadyExists     = "ALREADY_EXISTS"
+	errCodeEndorsementPolicy = "ENDORSEMENT_POLICY_FAILURE"
+	errCodeAccessDenied      = "ACCESS_DENIED"
+	errCodeChaincodeRejected = "CHAINCODE_VALIDATION_FAILED"
+	errCodeMVCCConflict      = "MVCC_READ_CONFLICT"
+	errCodeUnavailable       = "FABRIC_UNAVAILABLE"
+	errCodeTimeout           = "FABRIC_TIMEOUT"
+	errCodeCommitFailed      = "COMMIT_FAILED"
+	errCodeCircuitOpen       = "FABRIC_CIRCUIT_OPEN"
+)
+
+// commitError reports a transaction that was ordered but marked invalid by the committing peers
+type commitError struct {
+	TxID string
+	Code peer.TxValidationCode
+}
+
+func (e *commitError) Error() string {
+	return fmt.Sprintf("transaction %s failed to commit with status code %d (%s)", e.TxID, int32(e.Code), e.Code.String())
+}
+
+// FabricError is the HTTP translation of a failed Fabric call
+type FabricError struct {
+	Status  int
+	Code    string
+	Message string
+	TxID    string
+}
+
+// translateFabricError classifies an error from the Gateway into an HTTP status and machine-readable code
+func translateFabricError(err error) FabricError {
+	translated := FabricError{
+		Status:  http.StatusInternalServerError,
+		Code:    errCodeInternal,
+		Message: fabricErrorMessage(err),
+	}
+
+	if errors.Is(err, errCircuitOpen) {
+		translated.Status, translated.Code = http.StatusServiceUnavailable, errCodeCircuitOpen
+		return translated
+	}
+
+	var txErr *client.TransactionError
+	if errors.As(err, &txErr) {
+		translated.TxID = txErr.TransactionID
+	}
+
+	var commitErr *commitError
+	if errors.As(err, &commitErr) {
+		translated.TxID = commitErr.TxID
+		switch commitErr.Code {
+		case peer.TxValidationCode_ENDORSEMENT_POLICY_FAILURE:
+			translated.Status, translated.Code = http.StatusForbidden, errCodeEndorsementPolicy
+		case peer.TxValidationCode_MVCC_READ_CONFLICT, peer.TxValidationCode_PHANTOM_READ_CONFLICT, peer.TxValidationCode_DUPLICATE_TXID:
+			translated.Status, translated.Code = http.StatusConflict, errCodeMVCCConflict
+		default:
+			translated.Status, translated.Code = http.StatusUnprocessableEntity, errCodeCommitFailed
+		}
+		return translated
+	}
+
+	// Transport failures never carry a chaincode message, so classify them first
+	switch status.Code(err) {
+	case codes.Unavailable:
+		translated.Status, translated.Code = http.StatusServiceUnavailable, errCodeUnavailable
+		return translated
+	case codes.DeadlineExceeded, codes.Canceled:
+		translated.Status, translated.Code = http.StatusServiceUnavailable, errCodeTimeout
+		return translated
+	}
+
+	// Chaincode error messages are the most specific signal, so inspect them before other gRPC codes
+	message := strings.ToLower(translated.Message)
+	switch {
+	case strings.Contains(message, "already exists"):
+		translated.Status, translated.Code = http.StatusConflict, errCodeAlreadyExists
+		return translated
+	case strings.Contains(message, "does not exist"), strings.Contains(message, "not found"):
+		translated.Status, translated.Code = http.StatusNotFound, errCodeNotFound
+		return translated
+	case strings.Contains(message, "endorsement policy"):
+		translated.Status, translated.Code = http.StatusForbidden, errCodeEndorsementPolicy
+		return translated
+	case strings.Contains(message, "access denied"), strings.Contains(message, "creator org unknown"),
+		strings.Contains(message, "permission denied"):
+		translated.Status, translated.Code = http.StatusForbidden, errCodeAccessDenied
+		return translated
+	case strings.Contains(message, "must be"), strings.Contains(message, "cannot be empty"),
+		strings.Contains(message, "invalid"):
+		translated.Status, translated.Code = http.StatusUnprocessableEntity, errCodeChaincodeRejected
+		return translated
+	}
+
+	switch status.Code(err) {
+	case codes.PermissionDenied, codes.Unauthenticated:
+		translated.Status, translated.Code = http.StatusForbidden, errCodeAccessDenied
+	case codes.NotFound:
+		translated.Status, translated.Code = http.StatusNotFound, errCodeNotFound
+	case codes.AlreadyExists:
+		translated.Status, translated.Code = http.StatusConflict, errCodeAlreadyExists
+	case codes.InvalidArgument, codes.FailedPrecondition:
+		translated.Status, translated.Code = http.StatusUnprocessableEntity, errCodeChaincodeRejected
+	}
+	return translated
+}
+
+// fabricErrorMessage flattens a gRPC status and its per-peer details into one readable message
+func fabricErrorMessage(err error) string {
+	st, ok := status.FromError(err)
+	if !ok {
+		return err.Error()
+	}
+
+	messages := []string{st.Message()}
+	for _, detail := range st.Details() {
+		if errDetail, ok := detail.(*gatewaypb.ErrorDetail); ok {
+			messages = append(messages, fmt.Sprintf("%s (%s): %s", errDetail.GetAddress(), errDetail.GetMspId(), errDetail.GetMessage()))
+		}
+	}
+	return strings.Join(messages, "; ")
+}
+
+// respondFabricError writes the translated status for a failed Fabric call
+func respondFabricError(c *gin.Context, action string, err error) {
+	translated := translateFabricError(err)
+	logWithContext(c.Request.Context(), "Fabric call failed: %s: code=%s tx_id=%s: %v", action, translated.Code, translated.TxID, err)
+	if translated.Code == errCodeCircuitOpen {
+		retryAfter := int(math.Ceil(fabricBreaker.retryAfter(time.Now()).Seconds()))
+		c.Header("Retry-After", strconv.Itoa(max(retryAfter, 1)))
+	}
+
+	c.JSON(translated.Status, APIResponse{
+		Success:   false,
+		TxID:      translated.TxID,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Error: &APIError{
+			Code:    translated.Code,
+			Message: fmt.Sprintf("%s: %s", localize(c, action), translated.Message),
+		},
+	})
+}
@@ -0,0 +1,46 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestTranslateFabricError(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantCode   string
+	}{
+		{"already exists", status.Error(codes.Aborted, "failed to endorse transaction: chaincode response 500, the incident inc_1 already exists"), http.StatusConflict, errCodeAlreadyExists},
+		{"does not exist", status.Error(codes.Unknown, "evaluate call to endorser returned error: the document did:sih:1 does not exist"), http.StatusNotFound, errCodeNotFound},
+		{"chaincode validation", status.Error(codes.Aborted, "chaincode response 500, consentHash must be a valid SHA-256 hash"), http.StatusUnprocessableEntity, errCodeChaincodeRejected},
+		{"peer unavailable", status.Error(codes.Unavailable, "connection error: desc = \"transport: Error while dialing: dial tcp: invalid address\""), http.StatusServiceUnavailable, errCodeUnavailable},
+		{"deadline", status.Error(codes.DeadlineExceeded, "context deadline exceeded"), http.StatusServiceUnavailable, errCodeTimeout},
+		{"endorsement policy", &commitError{TxID: "tx1", Code: peer.TxValidationCode_ENDORSEMENT_POLICY_FAILURE}, http.StatusForbidden, errCodeEndorsementPolicy},
+		{"mvcc conflict", fmt.Errorf("wrapped: %w", &commitError{TxID: "tx2", Code: peer.TxValidationCode_MVCC_READ_CONFLICT}), http.StatusConflict, errCodeMVCCConflict},
+		{"unknown", errors.New("boom"), http.StatusInternalServerError, errCodeInternal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := translateFabricError(tt.err)
+			if got.Status != tt.wantStatus || got.Code != tt.wantCode {
+				t.Fatalf("expected %d %s, got %d %s (%s)", tt.wantStatus, tt.wantCode, got.Status, got.Code, got.Message)
+			}
+		})
+	}
+}
+
+func TestTranslateFabricError_CommitCarriesTxID(t *testing.T) {
+	got := translateFabricError(&commitError{TxID: "abc123", Code: peer.TxValidationCode_ENDORSEMENT_POLICY_FAILURE})
+	if got.TxID != "abc123" {
+		t.Fatalf("expected tx ID abc123, got %q", got.TxID)
+	}
+}
@@ -0,0 +1,744 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	errCodeERSSDisabled = "ERSS_DISABLED"
+
+	erssOutboxKey = "sih:erss:outbox"
+	erssFailedKey = "sih:erss:failed"
+	// erssReporter reports the incidents taken in from ERSS, which are never pushed back
+	erssReporter = "erss"
+	erssActor    = "erss-interop"
+
+	erssOutbound = "outbound"
+	erssInbound  = "inbound"
+
+	erssSubjectSOS      = "sos"
+	erssSubjectIncident = "incident"
+	// erssSOSSubType marks our SOS alerts, so ERSS updates about them find their way back
+	erssSOSSubType = "SOS"
+
+	maxERSSWebhookBody  = 64 << 10
+	maxQueuedERSSPushes = 10000
+	erssPushBatch       = 50
+)
+
+var (
+	erssEventTypes = []string{"POLICE", "MEDICAL", "FIRE", "WOMEN_SAFETY", "CHILD_HELP", "DISASTER", "OTHER"}
+	erssStatuses   = []string{"NEW", "DISPATCHED", "ATTENDED", "CLOSED"}
+
+	// erssIncidentStatus is the incident status each ERSS event status moves an incident to
+	erssIncidentStatus = map[string]string{"NEW": "open", "DISPATCHED": "acknowledged", "ATTENDED": "resolved", "CLOSED": "closed"}
+	// erssEventType is the ERSS event type each incident category is pushed as; the category itself
+	// goes in the sub-type
+	erssEventType = map[string]string{"missing_person": "POLICE", "medical": "MEDICAL", "accident": "MEDICAL", "theft": "POLICE", "harassment": "POLICE", "anomaly": "POLICE", "geofence": "POLICE"}
+	// erssCategory is the category an ERSS event type is taken in as, when its sub-type is not one
+	erssCategory = map[string]string{"MEDICAL": "medical", "WOMEN_SAFETY": "harassment", "CHILD_HELP": "missing_person"}
+)
+
+// ERSSEvent is an event in the ERSS CAD exchange format, as pushed to ERSS_ENDPOINT and received at
+// /callbacks/erss. Priority runs from 1, life-threatening, to 4. SourceRef is the originating
+// system's own ID for the event, which ERSS echoes in updates about it.
+type ERSSEvent struct {
+	EventID          string   `json:"eventId"`
+	SourceAgency     string   `json:"sourceAgency"`
+	SourceRef        string   `json:"sourceRef,omitempty"`
+	StateCode        string   `json:"stateCode,omitempty"`
+	EventType        string   `json:"eventType"`
+	EventSubType     string   `json:"eventSubType,omitempty"`
+	Priority         int      `json:"priority"`
+	Status           string   `json:"status"`
+	Latitude         *float64 `json:"latitude,omitempty"`
+	Longitude        *float64 `json:"longitude,omitempty"`
+	LocationAccuracy float64  `json:"locationAccuracy,omitempty"`
+	CallerRef        string   `json:"callerRef,omitempty"`
+	Description      string   `json:"description,omitempty"`
+	EventTime        string   `json:"eventTime"`
+}
+
+func (e ERSSEvent) Validate() ValidationErrors {
+	var v fieldValidator
+	v.identifier("eventId", e.EventID)
+	v.identifier("sourceAgency", e.SourceAgency)
+	if e.SourceRef != "" {
+		v.identifier("sourceRef", e.SourceRef)
+	}
+	v.oneOf("eventType", e.EventType, erssEventTypes)
+	v.oneOf("status", e.Status, erssStatuses)
+	if e.Priority < 1 || e.Priority > 4 {
+		v.add("priority", "must be between 1 and 4")
+	}
+	if (e.Latitude == nil) != (e.Longitude == nil) {
+		v.add("latitude", "latitude and longitude must be given together")
+	} else if e.Latitude != nil && (*e.Latitude < -90 || *e.Latitude > 90 || *e.Longitude < -180 || *e.Longitude > 180) {
+		v.add("latitude", "must be a valid position")
+	}
+	if len(e.Description) > 2000 {
+		v.add("description", "must be at most 2000 characters")
+	}
+	v.rfc3339("eventTime", e.EventTime)
+	return v.errors
+}
+
+// ERSSExchange is an exchange with ERSS as the chaincode anchors it
+type ERSSExchange struct {
+	DocType     string `json:"doc_type"`
+	ExchangeID  string `json:"exchange_id"`
+	Direction   string `json:"direction"`
+	ERSSEventID string `json:"erss_event_id"`
+	SubjectType string `json:"subject_type"`
+	SubjectID   string `json:"subject_id"`
+	PayloadHash string `json:"payload_hash"`
+	Status      string `json:"status"`
+	Attempts    int    `json:"attempts"`
+	ExchangedAt string `json:"exchanged_at"`
+	RecordedBy  string `json:"recorded_by"`
+	OwnerOrg    string `json:"owner_org,omitempty"`
+	TxID        string `json:"tx_id"`
+}
+
+// ERSSIntake acknowledges an event received from ERSS
+type ERSSIntake struct {
+	SubjectType string `json:"subject_type"`
+	SubjectID   string `json:"subject_id"`
+	// Created is set when the event opened a new incident
+	Created bool `json:"created"`
+	// Duplicate is set when the event was already taken in, and nothing was done
+	Duplicate bool `json:"duplicate,omitempty"`
+	// IncidentStatus is the incident's status after the event, for incidents
+	IncidentStatus string `json:"incident_status,omitempty"`
+	ExchangeID     string `json:"exchange_id"`
+}
+
+// ERSSPush is an SOS or incident waiting to be pushed to ERSS, or one that gave up
+type ERSSPush struct {
+	SubjectType string    `json:"subject_type"`
+	Event       ERSSEvent `json:"event"`
+	Attempts    int       `json:"attempts"`
+	NextAt      time.Time `json:"next_at"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+// erssOutbox holds pushes until ERSS accepts them. Pushes that run out of attempts are kept apart
+// until an operator retries them.
+type erssOutbox interface {
+	push(ctx context.Context, pushes []ERSSPush) error
+	// pop removes and returns up to n of the oldest pushes
+	pop(ctx context.Context, n int) ([]ERSSPush, error)
+	fail(ctx context.Context, p ERSSPush) error
+	failed(ctx context.Context) ([]ERSSPush, error)
+	// revive removes a failed push and returns it, or nil when there is none for the subject
+	revive(ctx context.Context, subjectID string) (*ERSSPush, error)
+}
+
+// erssService exchanges SOS alerts and incidents with the national 112 Emergency Response Support
+// System: it pushes our critical ones to ERSS_ENDPOINT and takes in ERSS events as incidents
+type erssService struct {
+	endpoint    string
+	token       string
+	secret      []byte
+	agency      string
+	stateCode   string
+	minSeverity string
+	client      *http.Client
+	outbox      erssOutbox
+	interval    time.Duration
+	maxAttempts int
+	// tolerance is how far an event's signed timestamp may be from our clock
+	tolerance time.Duration
+	// record anchors an exchange; ledger.RecordERSSExchange outside tests
+	record func(ctx context.Context, exchange ERSSExchange) error
+	// exchanges lists the exchanges anchored about a subject; ledger.ListERSSExchanges outside tests
+	exchanges func(ctx context.Context, subjectID string) ([]ERSSExchange, error)
+}
+
+var erss *erssService
+
+// initERSS runs after initDocumentCache. Pushes need ERSS_ENDPOINT and intake ERSS_SHARED_SECRET.
+func initERSS() {
+	endpoint, secret := getEnv("ERSS_ENDPOINT", ""), getEnv("ERSS_SHARED_SECRET", "")
+	if endpoint == "" && secret == "" {
+		log.Println("📟 ERSS_ENDPOINT and ERSS_SHARED_SECRET not set, 112 ERSS interop disabled")
+		return
+	}
+	var outbox erssOutbox = newMemoryERSSOutbox()
+	backend := "memory"
+	if documentCache != nil {
+		outbox, backend = redisERSSOutbox{documentCache}, "Redis"
+	}
+	erss = &erssService{
+		endpoint:    endpoint,
+		token:       getEnv("ERSS_API_TOKEN", ""),
+		secret:      []byte(secret),
+		agency:      getEnv("ERSS_AGENCY_ID", "SIH-TOURIST"),
+		stateCode:   getEnv("ERSS_STATE_CODE", ""),
+		minSeverity: getEnv("ERSS_PUSH_MIN_SEVERITY", "critical"),
+		client:      &http.Client{Timeout: getEnvDuration("ERSS_TIMEOUT", 10*time.Second)},
+		outbox:      outbox,
+		interval:    getEnvDuration("ERSS_RETRY_INTERVAL", 15*time.Second),
+		maxAttempts: getEnvInt("ERSS_MAX_ATTEMPTS", 8),
+		tolerance:   getEnvDuration("ERSS_SIGNATURE_TOLERANCE", 5*time.Minute),
+		record:      ledger.RecordERSSExchange,
+		exchanges:   ledger.ListERSSExchanges,
+	}
+	if !slices.Contains(incidentSeverities, erss.minSeverity) {
+		panic(fmt.Errorf("ERSS_PUSH_MIN_SEVERITY must be one of %v", incidentSeverities))
+	}
+	if len(validateDocumentID("ERSS_AGENCY_ID", erss.agency)) > 0 {
+		panic(fmt.Errorf("ERSS_AGENCY_ID must be an identifier"))
+	}
+	if erss.interval <= 0 || erss.maxAttempts < 1 {
+		panic(fmt.Errorf("ERSS_RETRY_INTERVAL must be positive and ERSS_MAX_ATTEMPTS at least 1"))
+	}
+	if erss.tolerance <= 0 {
+		panic(fmt.Errorf("ERSS_SIGNATURE_TOLERANCE must be positive"))
+	}
+	if endpoint == "" {
+		log.Printf("📟 Taking in 112 ERSS events; ERSS_ENDPOINT not set, nothing is pushed")
+		return
+	}
+	log.Printf("📟 Pushing SOS alerts and %s+ incidents to 112 ERSS as %s, queued in %s", erss.minSeverity, erss.agency, backend)
+}
+
+// erssPriority maps an incident severity to an ERSS priority
+func erssPriority(severity string) int {
+	return len(incidentSeverities) - slices.Index(incidentSeverities, severity)
+}
+
+// erssSeverity maps an ERSS priority to an incident severity
+func erssSeverity(priority int) string {
+	return incidentSeverities[len(incidentSeverities)-priority]
+}
+
+func (e *erssService) enqueue(ctx context.Context, subjectType string, event ERSSEvent) {
+	if e.endpoint == "" {
+		return
+	}
+	event.SourceAgency, event.SourceRef, event.StateCode, event.Status = e.agency, event.EventID, e.stateCode, "NEW"
+	if err := e.outbox.push(ctx, []ERSSPush{{SubjectType: subjectType, Event: event, NextAt: time.Now()}}); err != nil {
+		logWithContext(ctx, "📟 Failed to queue %s %s for ERSS: %v", subjectType, event.EventID, err)
+	}
+}
+
+// queueSOS pushes a raised SOS alert to ERSS at the highest priority
+func (e *erssService) queueSOS(ctx context.Context, alert SOSNotification) {
+	lat, lng := alert.Latitude, alert.Longitude
+	description := "Tourist SOS"
+	if alert.Message != "" {
+		description += ": " + alert.Message
+	}
+	e.enqueue(ctx, erssSubjectSOS, ERSSEvent{
+		EventID: alert.AlertID, EventType: "POLICE", EventSubType: erssSOSSubType, Priority: 1,
+		Latitude: &lat, Longitude: &lng, LocationAccuracy: alert.Accuracy,
+		CallerRef: alert.DigitalID, Description: description, EventTime: alert.RaisedAt,
+	})
+}
+
+// queueIncident pushes a new incident at or above ERSS_PUSH_MIN_SEVERITY. Incidents taken in from
+// ERSS are not pushed back.
+func (e *erssService) queueIncident(ctx context.Context, req CreateIncidentRequest) {
+	if req.Reporter == erssReporter || slices.Index(incidentSeverities, req.Severity) < slices.Index(incidentSeverities, e.minSeverity) {
+		return
+	}
+	eventType, ok := erssEventType[req.Category]
+	if !ok {
+		eventType = "OTHER"
+	}
+	category := req.Category
+	if category == "" {
+		category = "other"
+	}
+	e.enqueue(ctx, erssSubjectIncident, ERSSEvent{
+		EventID: req.IncidentID, EventType: eventType, EventSubType: strings.ToUpper(category), Priority: erssPriority(req.Severity),
+		Latitude: req.Latitude, Longitude: req.Longitude, CallerRef: req.DigitalID,
+		Description: fmt.Sprintf("Tourist %s incident reported by %s", strings.ReplaceAll(category, "_", " "), req.Reporter),
+		EventTime:   time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+// run pushes the queued events every ERSS_RETRY_INTERVAL until ctx is done
+func (e *erssService) run(ctx context.Context) {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := e.flush(withTarget(ctx, defaultTarget)); err != nil {
+				log.Printf("📟 Failed to push to ERSS: %v", err)
+			}
+		}
+	}
+}
+
+// flush sends the pushes that are due. A failed push is retried with exponential backoff until
+// ERSS_MAX_ATTEMPTS, then set aside and anchored as failed.
+func (e *erssService) flush(ctx context.Context) error {
+	pushes, err := e.outbox.pop(ctx, erssPushBatch)
+	if err != nil || len(pushes) == 0 {
+		return err
+	}
+	var later []ERSSPush
+	now := time.Now()
+	for _, p := range pushes {
+		if p.NextAt.After(now) {
+			later = append(later, p)
+			continue
+		}
+		p.Attempts++
+		erssEventID, hash, err := e.send(ctx, p.Event)
+		exchange := ERSSExchange{
+			ExchangeID: "ERSS-OUT-" + p.Event.EventID, Direction: erssOutbound, ERSSEventID: erssEventID, SubjectType: p.SubjectType, SubjectID: p.Event.EventID,
+			PayloadHash: hash, Status: notifyDelivered, Attempts: p.Attempts, ExchangedAt: time.Now().UTC().Format(time.RFC3339),
+		}
+		if err != nil {
+			p.LastError = err.Error()
+			if p.Attempts < e.maxAttempts {
+				p.NextAt = now.Add(e.interval * time.Duration(1<<min(p.Attempts-1, 6)))
+				later = append(later, p)
+				continue
+			}
+			log.Printf("📟 Gave up pushing %s %s to ERSS after %d attempts: %v", p.SubjectType, p.Event.EventID, p.Attempts, err)
+			if err := e.outbox.fail(ctx, p); err != nil {
+				log.Printf("📟 Failed to set aside the push of %s: %v", p.Event.EventID, err)
+			}
+			exchange.ERSSEventID, exchange.Status = p.Event.EventID, notifyFailed
+		}
+		if err := e.record(ctx, exchange); err != nil {
+			log.Printf("📟 Failed to anchor the ERSS push of %s %s: %v", p.SubjectType, p.Event.EventID, err)
+		}
+	}
+	if len(later) > 0 {
+		return e.outbox.push(context.WithoutCancel(ctx), later)
+	}
+	return nil
+}
+
+// send posts an event to ERSS and returns the ERSS event ID it was filed under, which is our ID
+// unless ERSS answers with its own, and the SHA-256 of the body sent
+func (e *erssService) send(ctx context.Context, event ERSSEvent) (string, string, error) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return "", "", err
+	}
+	sum := sha256.Sum256(body)
+	hash := hex.EncodeToString(sum[:])
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return "", hash, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.token != "" {
+		req.Header.Set("Authorization", "Bearer "+e.token)
+	}
+	if len(e.secret) > 0 {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(signatureTimestampHeader, timestamp)
+		req.Header.Set(signatureHeader, webhookSignature(e.secret, timestamp, body))
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return "", hash, err
+	}
+	defer resp.Body.Close()
+	reply, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", hash, errors.New("ERSS responded with " + resp.Status)
+	}
+	var filed struct {
+		EventID string `json:"eventId"`
+	}
+	if json.Unmarshal(reply, &filed) != nil || filed.EventID == "" {
+		filed.EventID = event.EventID
+	}
+	return filed.EventID, hash, nil
+}
+
+// erssIncidentRequest maps an ERSS event to the incident it opens. The summary hash covers the
+// event as received.
+func erssIncidentRequest(event ERSSEvent, payloadHash string) CreateIncidentRequest {
+	category := strings.ToLower(event.EventSubType)
+	if !slices.Contains(incidentCategories, category) {
+		if category = erssCategory[event.EventType]; category == "" {
+			category = "other"
+		}
+	}
+	req := CreateIncidentRequest{
+		IncidentID:          "ERSS-" + event.EventID,
+		IncidentSummaryHash: payloadHash,
+		Reporter:            erssReporter,
+		Severity:            erssSeverity(event.Priority),
+		Category:            category,
+		Latitude:            event.Latitude,
+		Longitude:           event.Longitude,
+	}
+	if digitalIDRegex.MatchString(event.CallerRef) {
+		req.DigitalID = event.CallerRef
+	}
+	return req
+}
+
+// intake takes in an ERSS event. An event about one of our pushes is recorded against our SOS or
+// incident; any other opens an incident, and later events about it move its status forward. An
+// event whose status was already taken in is answered as a duplicate without touching the ledger.
+func (e *erssService) intake(ctx context.Context, event ERSSEvent, payloadHash string) (*ERSSIntake, error) {
+	if errs := event.Validate(); len(errs) > 0 {
+		return nil, errs
+	}
+	intake := &ERSSIntake{SubjectType: erssSubjectIncident, SubjectID: "ERSS-" + event.EventID}
+	if event.SourceAgency == e.agency {
+		if event.SourceRef == "" {
+			return nil, ValidationErrors{{Field: "sourceRef", Message: "is required for updates about our events"}}
+		}
+		intake.SubjectID = event.SourceRef
+		if event.EventSubType == erssSOSSubType {
+			intake.SubjectType = erssSubjectSOS
+		}
+	}
+	intake.ExchangeID = "ERSS-IN-" + event.EventID + "-" + strings.ToLower(event.Status)
+
+	exchanges, err := e.exchanges(ctx, intake.SubjectID)
+	if err != nil && translateFabricError(err).Code != errCodeNotFound {
+		return nil, err
+	}
+	for _, exchange := range exchanges {
+		if exchange.ExchangeID == intake.ExchangeID {
+			intake.Duplicate = true
+			return intake, nil
+		}
+	}
+
+	if intake.SubjectType == erssSubjectIncident {
+		incident, err := ledger.GetIncident(ctx, intake.SubjectID)
+		switch {
+		case err == nil:
+			intake.IncidentStatus = incident.Status
+		case translateFabricError(err).Code == errCodeNotFound && event.SourceAgency != e.agency:
+			if _, err := ledger.CreateIncident(ctx, erssIncidentRequest(event, payloadHash)); err != nil && translateFabricError(err).Code != errCodeAlreadyExists {
+				return nil, err
+			}
+			intake.Created, intake.IncidentStatus = true, "open"
+		default:
+			return nil, err
+		}
+		next := erssIncidentStatus[event.Status]
+		if slices.Index(incidentStatuses, next) > slices.Index(incidentStatuses, intake.IncidentStatus) {
+			if _, err := ledger.UpdateIncidentStatus(ctx, intake.SubjectID, UpdateIncidentStatusRequest{Status: next, Updater: erssReporter}); err != nil {
+				return nil, err
+			}
+			intake.IncidentStatus = next
+		}
+	}
+
+	err = e.record(ctx, ERSSExchange{
+		ExchangeID: intake.ExchangeID, Direction: erssInbound, ERSSEventID: event.EventID, SubjectType: intake.SubjectType, SubjectID: intake.SubjectID,
+		PayloadHash: payloadHash, Status: "received", Attempts: 1, ExchangedAt: time.Now().UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return intake, nil
+}
+
+// RecordERSSExchange anchors an exchange with ERSS
+func (ledgerService) RecordERSSExchange(ctx context.Context, exchange ERSSExchange) error {
+	_, err := submitTransaction(ctx, "RecordERSSExchange", exchange.ExchangeID, exchange.Direction, exchange.ERSSEventID, exchange.SubjectType,
+		exchange.SubjectID, exchange.PayloadHash, exchange.Status, strconv.Itoa(exchange.Attempts), exchange.ExchangedAt, erssActor)
+	return err
+}
+
+// ListERSSExchanges returns the exchanges with ERSS about an SOS or incident, earliest first
+func (ledgerService) ListERSSExchanges(ctx context.Context, subjectID string) ([]ERSSExchange, error) {
+	if errs := validateDocumentID("subjectId", subjectID); len(errs) > 0 {
+		return nil, errs
+	}
+	result, err := evaluateTransaction(ctx, "GetERSSExchangesBySubject", subjectID)
+	if err != nil {
+		return nil, err
+	}
+	exchanges, err := decodeDocument[[]ERSSExchange](result, "ERSS exchange")
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(exchanges, func(i, j int) bool { return exchanges[i].ExchangedAt < exchanges[j].ExchangedAt })
+	return filterOwned(tenantFromContext(ctx), exchanges, func(x ERSSExchange) string { return x.OwnerOrg }), nil
+}
+
+func erssDisabled(c *gin.Context) bool {
+	if erss == nil {
+		respondError(c, http.StatusServiceUnavailable, errCodeERSSDisabled, "112 ERSS interop is not enabled")
+		return true
+	}
+	return false
+}
+
+// erssWebhook takes in events from ERSS. It sits outside /api/v1 because it is authenticated by the
+// signature ERSS computes with ERSS_SHARED_SECRET rather than an API key. The signature covers a
+// timestamp that must be within ERSS_SIGNATURE_TOLERANCE of our clock, and a replay inside that
+// window is caught by intake as a duplicate.
+func erssWebhook(c *gin.Context) {
+	if erssDisabled(c) {
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxERSSWebhookBody+1))
+	if err != nil || len(body) > maxERSSWebhookBody {
+		respondError(c, http.StatusBadRequest, errCodeValidation, "The event body could not be read")
+		return
+	}
+	timestamp := c.GetHeader(signatureTimestampHeader)
+	if len(erss.secret) == 0 || !validWebhookSignature(erss.secret, timestamp, body, c.GetHeader(signatureHeader)) ||
+		!freshWebhookTimestamp(timestamp, time.Now(), erss.tolerance) {
+		respondError(c, http.StatusForbidden, errCodeAccessDenied, "Webhook signature verification failed")
+		return
+	}
+	var event ERSSEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		respondError(c, http.StatusBadRequest, errCodeValidation, "The body is not an ERSS event")
+		return
+	}
+	sum := sha256.Sum256(body)
+
+	ctx := withTarget(c.Request.Context(), defaultTarget)
+	intake, err := erss.intake(ctx, event, hex.EncodeToString(sum[:]))
+	if err != nil {
+		respondServiceError(c, "Failed to take in ERSS event", err)
+		return
+	}
+	code := http.StatusOK
+	if intake.Created {
+		code = http.StatusCreated
+	}
+	respondData(c, code, intake)
+}
+
+func listERSSExchanges(c *gin.Context) {
+	id, ok := validPathID(c, "subjectId")
+	if !ok {
+		return
+	}
+	exchanges, err := ledger.ListERSSExchanges(c.Request.Context(), id)
+	if err != nil {
+		respondServiceError(c, "Failed to list ERSS exchanges", err)
+		return
+	}
+	respondData(c, http.StatusOK, exchanges)
+}
+
+func listFailedERSSPushes(c *gin.Context) {
+	if erssDisabled(c) {
+		return
+	}
+	pushes, err := erss.outbox.failed(c.Request.Context())
+	if err != nil {
+		respondServiceError(c, "Failed to list failed ERSS pushes", err)
+		return
+	}
+	if pushes == nil {
+		pushes = []ERSSPush{}
+	}
+	respondData(c, http.StatusOK, pushes)
+}
+
+// retryERSSPush queues a push that ran out of attempts again, with its attempts reset
+func retryERSSPush(c *gin.Context) {
+	if erssDisabled(c) {
+		return
+	}
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	setAuditTarget(c, id)
+	ctx := c.Request.Context()
+
+	p, err := erss.outbox.revive(ctx, id)
+	if err != nil {
+		respondServiceError(c, "Failed to retry ERSS push", err)
+		return
+	}
+	if p == nil {
+		respondError(c, http.StatusNotFound, errCodeNotFound, "No failed ERSS push for this SOS or incident")
+		return
+	}
+	p.Attempts, p.NextAt, p.LastError = 0, time.Now(), ""
+	if err := erss.outbox.push(ctx, []ERSSPush{*p}); err != nil {
+		respondServiceError(c, "Failed to retry ERSS push", err)
+		return
+	}
+	respondData(c, http.StatusAccepted, p)
+}
+
+// redisERSSOutbox keeps pushes in a Redis list and failed ones in a hash by subject, so pushes
+// survive a restart and any instance can send them
+type redisERSSOutbox struct {
+	redis *redisClient
+}
+
+func (o redisERSSOutbox) push(ctx context.Context, pushes []ERSSPush) error {
+	args := []string{"RPUSH", erssOutboxKey}
+	for _, p := range pushes {
+		data, err := json.Marshal(p)
+		if err != nil {
+			return err
+		}
+		args = append(args, string(data))
+	}
+	_, err := o.redis.Do(ctx, args...)
+	return err
+}
+
+func (o redisERSSOutbox) pop(ctx context.Context, n int) ([]ERSSPush, error) {
+	reply, err := o.redis.Do(ctx, "LPOP", erssOutboxKey, strconv.Itoa(n))
+	if errors.Is(err, errRedisNil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeERSSPushes(reply), nil
+}
+
+func (o redisERSSOutbox) fail(ctx context.Context, p ERSSPush) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	_, err = o.redis.Do(ctx, "HSET", erssFailedKey, p.Event.EventID, string(data))
+	return err
+}
+
+func (o redisERSSOutbox) failed(ctx context.Context) ([]ERSSPush, error) {
+	reply, err := o.redis.Do(ctx, "HVALS", erssFailedKey)
+	if err != nil {
+		return nil, err
+	}
+	pushes := decodeERSSPushes(reply)
+	sort.Slice(pushes, func(i, j int) bool { return pushes[i].Event.EventTime < pushes[j].Event.EventTime })
+	return pushes, nil
+}
+
+func (o redisERSSOutbox) revive(ctx context.Context, subjectID string) (*ERSSPush, error) {
+	data, err := o.redis.Do(ctx, "HGET", erssFailedKey, subjectID)
+	if errors.Is(err, errRedisNil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	raw, _ := data.([]byte)
+	var p ERSSPush
+	if err := json.Unmarshal(raw, &p); err != nil {
+		return nil, err
+	}
+	removed, err := o.redis.Do(ctx, "HDEL", erssFailedKey, subjectID)
+	if err != nil {
+		return nil, err
+	}
+	// Another instance revived it first
+	if n, _ := removed.(int64); n != 1 {
+		return nil, nil
+	}
+	return &p, nil
+}
+
+func decodeERSSPushes(reply interface{}) []ERSSPush {
+	items, _ := reply.([]interface{})
+	pushes := make([]ERSSPush, 0, len(items))
+	for _, item := range items {
+		raw, _ := item.([]byte)
+		var p ERSSPush
+		if err := json.Unmarshal(raw, &p); err != nil {
+			log.Printf("📟 Dropped an unreadable queued ERSS push: %v", err)
+			continue
+		}
+		pushes = append(pushes, p)
+	}
+	return pushes
+}
+
+// memoryERSSOutbox serves a single gateway instance; queued pushes are lost on restart
+type memoryERSSOutbox struct {
+	mu      sync.Mutex
+	pending []ERSSPush
+	gaveUp  map[string]ERSSPush
+}
+
+func newMemoryERSSOutbox() *memoryERSSOutbox {
+	return &memoryERSSOutbox{gaveUp: map[string]ERSSPush{}}
+}
+
+func (o *memoryERSSOutbox) push(_ context.Context, pushes []ERSSPush) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.pending)+len(pushes) > maxQueuedERSSPushes {
+		return fmt.Errorf("the outbox already holds %d pushes", len(o.pending))
+	}
+	o.pending = append(o.pending, pushes...)
+	return nil
+}
+
+func (o *memoryERSSOutbox) pop(_ context.Context, n int) ([]ERSSPush, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	n = min(n, len(o.pending))
+	pushes := append([]ERSSPush(nil), o.pending[:n]...)
+	o.pending = o.pending[n:]
+	return pushes, nil
+}
+
+func (o *memoryERSSOutbox) fail(_ context.Context, p ERSSPush) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.gaveUp[p.Event.EventID] = p
+	return nil
+}
+
+func (o *memoryERSSOutbox) failed(_ context.Context) ([]ERSSPush, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	pushes := make([]ERSSPush, 0, len(o.gaveUp))
+	for _, p := range o.gaveUp {
+		pushes = append(pushes, p)
+	}
+	sort.Slice(pushes, func(i, j int) bool { return pushes[i].Event.EventTime < pushes[j].Event.EventTime })
+	return pushes, nil
+}
+
+func (o *memoryERSSOutbox) revive(_ context.Context, subjectID string) (*ERSSPush, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	p, ok := o.gaveUp[subjectID]
+	if !ok {
+		return nil, nil
+	}
+	delete(o.gaveUp, subjectID)
+	return &p, nil
+}
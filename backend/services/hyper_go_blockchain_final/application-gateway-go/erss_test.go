@@ -0,0 +1,221 @@
+package gateway
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestERSSMapping(t *testing.T) {
+	for severity, priority := range map[string]int{"critical": 1, "high": 2, "medium": 3, "low": 4} {
+		if got := erssPriority(severity); got != priority {
+			t.Errorf("expected %s pushed at priority %d, got %d", severity, priority, got)
+		}
+		if got := erssSeverity(priority); got != severity {
+			t.Errorf("expected priority %d taken in as %s, got %s", priority, severity, got)
+		}
+	}
+
+	lat, lng := 25.5788, 91.8933
+	for _, tc := range []struct {
+		eventType, subType, category string
+	}{
+		{"MEDICAL", "ACCIDENT", "accident"},
+		{"MEDICAL", "", "medical"},
+		{"WOMEN_SAFETY", "STALKING", "harassment"},
+		{"FIRE", "", "other"},
+	} {
+		req := erssIncidentRequest(ERSSEvent{EventID: "112-ML-0042", EventType: tc.eventType, EventSubType: tc.subType, Priority: 2, CallerRef: "did:sih:tourist_001", Latitude: &lat, Longitude: &lng}, strings.Repeat("ab", 32))
+		if req.Category != tc.category {
+			t.Errorf("expected %s/%s taken in as %s, got %s", tc.eventType, tc.subType, tc.category, req.Category)
+		}
+		if req.IncidentID != "ERSS-112-ML-0042" || req.Reporter != erssReporter || req.Severity != "high" || req.DigitalID != "did:sih:tourist_001" {
+			t.Errorf("unexpected incident %+v", req)
+		}
+		if errs := req.Validate(); len(errs) > 0 {
+			t.Errorf("expected a valid incident, got %v", errs)
+		}
+	}
+	if req := erssIncidentRequest(ERSSEvent{EventID: "112-ML-0043", EventType: "POLICE", Priority: 1, CallerRef: "+919800000000"}, "hash"); req.DigitalID != "" {
+		t.Errorf("expected a caller that is not a DID left out, got %q", req.DigitalID)
+	}
+}
+
+func TestERSSPushes(t *testing.T) {
+	const secret = "erss-secret"
+	var mu sync.Mutex
+	var received []ERSSEvent
+	failing := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		timestamp := r.Header.Get(signatureTimestampHeader)
+		if !validWebhookSignature([]byte(secret), timestamp, body, r.Header.Get(signatureHeader)) || !freshWebhookTimestamp(timestamp, time.Now(), time.Minute) ||
+			r.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("expected a signed, authorized push, got headers %v", r.Header)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if failing {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		var event ERSSEvent
+		json.Unmarshal(body, &event)
+		received = append(received, event)
+		w.Write([]byte(`{"eventId": "112-ML-9001"}`))
+	}))
+	defer server.Close()
+
+	var recorded []ERSSExchange
+	e := &erssService{
+		endpoint: server.URL, token: "token", secret: []byte(secret), agency: "SIH-TOURIST", stateCode: "ML", minSeverity: "critical",
+		client: server.Client(), outbox: newMemoryERSSOutbox(), interval: time.Millisecond, maxAttempts: 2,
+		record: func(_ context.Context, exchange ERSSExchange) error {
+			recorded = append(recorded, exchange)
+			return nil
+		},
+	}
+	ctx := context.Background()
+	lat, lng := 25.5788, 91.8933
+
+	e.queueSOS(ctx, SOSNotification{AlertID: "SOS-1", DigitalID: "did:sih:tourist_001", Latitude: lat, Longitude: lng, RaisedAt: "2026-10-16T10:00:00Z"})
+	e.queueIncident(ctx, CreateIncidentRequest{IncidentID: "INC-LOW", Reporter: "police_01", Severity: "high", Category: "theft"})
+	e.queueIncident(ctx, CreateIncidentRequest{IncidentID: "INC-ERSS", Reporter: erssReporter, Severity: "critical", Category: "medical"})
+	e.queueIncident(ctx, CreateIncidentRequest{IncidentID: "INC-1", Reporter: "police_01", Severity: "critical", Category: "accident", Latitude: &lat, Longitude: &lng})
+	if pending, _ := e.outbox.(*memoryERSSOutbox); len(pending.pending) != 2 {
+		t.Fatalf("expected only the SOS and the critical incident of our own queued, got %d", len(pending.pending))
+	}
+
+	// The first attempts fail and are retried; the second failures give up
+	for i := 0; i < 2; i++ {
+		time.Sleep(5 * time.Millisecond)
+		if err := e.flush(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	failed, _ := e.outbox.failed(ctx)
+	if len(failed) != 2 || failed[0].Attempts != 2 || failed[0].LastError == "" {
+		t.Fatalf("expected both pushes set aside after two attempts, got %+v", failed)
+	}
+	if len(recorded) != 2 || recorded[0].Status != notifyFailed || recorded[0].Direction != erssOutbound {
+		t.Fatalf("expected the failures anchored, got %+v", recorded)
+	}
+
+	mu.Lock()
+	failing = false
+	mu.Unlock()
+	p, _ := e.outbox.revive(ctx, "SOS-1")
+	if p == nil {
+		t.Fatal("expected the failed SOS push to be revived")
+	}
+	if p, _ := e.outbox.revive(ctx, "SOS-1"); p != nil {
+		t.Error("expected a push revived only once")
+	}
+	p.Attempts, p.NextAt = 0, time.Now()
+	e.outbox.push(ctx, []ERSSPush{*p})
+	if err := e.flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(received) != 1 {
+		t.Fatalf("expected the SOS delivered, got %d events", len(received))
+	}
+	event := received[0]
+	if event.EventSubType != erssSOSSubType || event.Priority != 1 || event.SourceAgency != "SIH-TOURIST" || event.SourceRef != "SOS-1" || event.StateCode != "ML" || event.Status != "NEW" {
+		t.Errorf("unexpected SOS event %+v", event)
+	}
+	last := recorded[len(recorded)-1]
+	if last.Status != notifyDelivered || last.ERSSEventID != "112-ML-9001" || last.ExchangeID != "ERSS-OUT-SOS-1" || last.Attempts != 1 || len(last.PayloadHash) != 64 {
+		t.Errorf("unexpected delivered exchange %+v", last)
+	}
+}
+
+func TestERSSWebhook(t *testing.T) {
+	const secret = "erss-secret"
+	var recorded []ERSSExchange
+	erss = &erssService{secret: []byte(secret), agency: "SIH-TOURIST", minSeverity: "critical", outbox: newMemoryERSSOutbox(), tolerance: 5 * time.Minute,
+		record: func(_ context.Context, exchange ERSSExchange) error {
+			recorded = append(recorded, exchange)
+			return nil
+		},
+		exchanges: func(_ context.Context, subjectID string) ([]ERSSExchange, error) {
+			var exchanges []ERSSExchange
+			for _, exchange := range recorded {
+				if exchange.SubjectID == subjectID {
+					exchanges = append(exchanges, exchange)
+				}
+			}
+			return exchanges, nil
+		},
+	}
+	defer func() { erss = nil }()
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/callbacks/erss", erssWebhook)
+
+	post := func(body, timestamp, signature string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/callbacks/erss", strings.NewReader(body))
+		req.Header.Set(signatureHeader, signature)
+		if timestamp != "" {
+			req.Header.Set(signatureTimestampHeader, timestamp)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	sign := func(timestamp, body string) string {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(timestamp + "." + body))
+		return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	stale := strconv.FormatInt(time.Now().Add(-10*time.Minute).Unix(), 10)
+
+	// ERSS reports it dispatched a unit to our SOS
+	body := `{"eventId": "112-ML-9001", "sourceAgency": "SIH-TOURIST", "sourceRef": "SOS-1", "eventType": "POLICE", "eventSubType": "SOS", "priority": 1, "status": "DISPATCHED", "eventTime": "2026-10-16T10:02:00Z"}`
+	bodyOnly := hmac.New(sha256.New, []byte(secret))
+	bodyOnly.Write([]byte(body))
+	for name, w := range map[string]*httptest.ResponseRecorder{
+		"a bad signature":             post(body, now, sign(now, body+" ")),
+		"a signature without time":    post(body, "", "sha256="+hex.EncodeToString(bodyOnly.Sum(nil))),
+		"a signature of another time": post(body, now, sign(stale, body)),
+		"a stale signature":           post(body, stale, sign(stale, body)),
+	} {
+		if w.Code != http.StatusForbidden {
+			t.Errorf("expected %s refused, got %d", name, w.Code)
+		}
+	}
+	w := post(body, now, sign(now, body))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected the update taken in, got %d %s", w.Code, w.Body)
+	}
+	if len(recorded) != 1 || recorded[0].SubjectType != erssSubjectSOS || recorded[0].SubjectID != "SOS-1" || recorded[0].ExchangeID != "ERSS-IN-112-ML-9001-dispatched" || recorded[0].Direction != erssInbound {
+		t.Errorf("unexpected inbound exchange %+v", recorded)
+	}
+
+	// A replay inside the window is answered without anchoring the event again
+	w = post(body, now, sign(now, body))
+	var replay struct {
+		Data ERSSIntake `json:"data"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &replay)
+	if w.Code != http.StatusOK || !replay.Data.Duplicate || len(recorded) != 1 {
+		t.Errorf("expected the replay answered as a duplicate, got %d %s and %d exchanges", w.Code, w.Body, len(recorded))
+	}
+
+	invalid := `{"eventId": "112-ML-9002", "sourceAgency": "ERSS-ML", "eventType": "FLOOD", "priority": 9, "status": "NEW", "eventTime": "2026-10-16T10:02:00Z"}`
+	if w := post(invalid, now, sign(now, invalid)); w.Code != http.StatusBadRequest {
+		t.Errorf("expected an invalid event refused, got %d %s", w.Code, w.Body)
+	}
+}
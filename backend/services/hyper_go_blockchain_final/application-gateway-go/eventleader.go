@@ -0,0 +1,247 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+const (
+	eventLeaderKey        = "sih:events:leader"
+	eventCheckpointPrefix = "sih:events:checkpoint:"
+)
+
+// renewEventLeader extends the lease only while it still holds this instance's name, and
+// releaseEventLeader gives it up on shutdown so another replica takes over at once
+const (
+	renewEventLeader   = `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("PEXPIRE", KEYS[1], ARGV[2]) end return 0`
+	releaseEventLeader = `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("DEL", KEYS[1]) end return 0`
+)
+
+// errLeadershipChanged ends an event stream so it restarts for the replica's new role
+var errLeadershipChanged = errors.New("event leadership changed")
+
+// EventLeadership reports which gateway replica processes chaincode events
+type EventLeadership struct {
+	Enabled  bool   `json:"enabled"`
+	Instance string `json:"instance"`
+	Leader   bool   `json:"leader"`
+	Holder   string `json:"holder,omitempty"`
+	Since    string `json:"since,omitempty"`
+}
+
+// eventLeader elects the replica that runs the side effects of chaincode events, such as push
+// notifications and escalations, and checkpoints how far it got. The lease is sticky: the leader
+// renews it every third of EVENT_LEADER_TTL and keeps it until it stops or loses Redis. Replicas
+// outside EVENT_LEADER_REGION only take a lease left vacant for a whole round, so leadership
+// stays near the preferred peers while a replica there is up.
+type eventLeader struct {
+	redis     *redisClient
+	instance  string
+	ttl       time.Duration
+	preferred bool
+
+	mu      sync.Mutex
+	leader  bool
+	holder  string
+	since   time.Time
+	vacant  bool
+	changed chan struct{}
+}
+
+var eventLeadership *eventLeader
+
+// newInstanceID names this gateway process in leases and job runs
+func newInstanceID() string {
+	hostname, _ := os.Hostname()
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return hostname + "-" + hex.EncodeToString(suffix)
+}
+
+// initEventLeadership runs after initDocumentCache. Without EVENT_LEADER_ELECTION every replica
+// processes every event, relying on claims to act on each only once.
+func initEventLeadership() {
+	if !getEnvBool("EVENT_LEADER_ELECTION", false) {
+		return
+	}
+	if documentCache == nil {
+		panic(errors.New("EVENT_LEADER_ELECTION requires Redis; set REDIS_URL"))
+	}
+	preferredRegion := getEnv("EVENT_LEADER_REGION", "")
+	l := &eventLeader{
+		redis:     documentCache,
+		instance:  newInstanceID(),
+		ttl:       getEnvDuration("EVENT_LEADER_TTL", 15*time.Second),
+		preferred: preferredRegion == "" || preferredRegion == regions.local,
+		changed:   make(chan struct{}),
+	}
+	if l.ttl < time.Second {
+		panic(errors.New("EVENT_LEADER_TTL must be at least 1s"))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), l.ttl)
+	defer cancel()
+	l.campaign(ctx)
+	eventLeadership = l
+	log.Printf("👑 Event leadership elected through Redis as %s, leading: %t", l.instance, l.isLeader())
+}
+
+// isLeader reports whether this replica processes events. Without election every replica does.
+func (l *eventLeader) isLeader() bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.leader
+}
+
+// watch returns whether this replica leads and a channel closed when that changes
+func (l *eventLeader) watch() (bool, <-chan struct{}) {
+	if l == nil {
+		return true, nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.leader, l.changed
+}
+
+func (l *eventLeader) set(leader bool, holder string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.holder = holder
+	if leader == l.leader {
+		return
+	}
+	l.leader = leader
+	if leader {
+		l.since = time.Now()
+		log.Printf("👑 %s now leads chaincode event processing", l.instance)
+	} else {
+		l.since = time.Time{}
+		log.Printf("👑 %s no longer leads chaincode event processing", l.instance)
+	}
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// campaign renews the lease this replica holds, or takes a vacant one
+func (l *eventLeader) campaign(ctx context.Context) {
+	ttl := strconv.FormatInt(l.ttl.Milliseconds(), 10)
+	if l.isLeader() {
+		reply, err := l.redis.Do(ctx, "EVAL", renewEventLeader, "1", eventLeaderKey, l.instance, ttl)
+		if n, ok := reply.(int64); err != nil || !ok || n != 1 {
+			// Another replica may take over once the lease lapses, so stop acting on events now
+			if err != nil {
+				log.Printf("👑 Failed to renew event leadership: %v", err)
+			}
+			l.set(false, "")
+		}
+		return
+	}
+
+	reply, err := l.redis.Do(ctx, "GET", eventLeaderKey)
+	switch {
+	case err == nil:
+		holder, _ := reply.([]byte)
+		l.mu.Lock()
+		l.vacant = false
+		l.mu.Unlock()
+		l.set(false, string(holder))
+		return
+	case !errors.Is(err, errRedisNil):
+		log.Printf("👑 Failed to read event leadership: %v", err)
+		return
+	}
+	l.mu.Lock()
+	wait := !l.preferred && !l.vacant
+	l.vacant = true
+	l.mu.Unlock()
+	if wait {
+		return
+	}
+	_, err = l.redis.Do(ctx, "SET", eventLeaderKey, l.instance, "NX", "PX", ttl)
+	switch {
+	case err == nil:
+		l.set(true, l.instance)
+	case !errors.Is(err, errRedisNil):
+		log.Printf("👑 Failed to take event leadership: %v", err)
+	}
+}
+
+// run campaigns every third of the lease until ctx is done, then releases a lease it holds
+func (l *eventLeader) run(ctx context.Context) {
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if l.isLeader() {
+				releaseCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 2*time.Second)
+				l.redis.Do(releaseCtx, "EVAL", releaseEventLeader, "1", eventLeaderKey, l.instance)
+				cancel()
+			}
+			return
+		case <-ticker.C:
+			campaignCtx, cancel := context.WithTimeout(ctx, l.ttl/3)
+			l.campaign(campaignCtx)
+			cancel()
+		}
+	}
+}
+
+func (l *eventLeader) status() EventLeadership {
+	if l == nil {
+		return EventLeadership{}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	status := EventLeadership{Enabled: true, Instance: l.instance, Leader: l.leader, Holder: l.holder}
+	if l.leader {
+		status.Since = l.since.UTC().Format(time.RFC3339)
+	}
+	return status
+}
+
+// loadEventCheckpoint returns the position after the last event the leader processed on a target,
+// or nil to start from the next block
+func loadEventCheckpoint(ctx context.Context, target *fabricTarget) (*client.InMemoryCheckpointer, error) {
+	reply, err := documentCache.Do(ctx, "HMGET", eventCheckpointPrefix+target.Name, "block", "tx_id")
+	if err != nil {
+		return nil, err
+	}
+	fields, _ := reply.([]interface{})
+	if len(fields) != 2 || fields[0] == nil {
+		return nil, nil
+	}
+	block, _ := fields[0].([]byte)
+	txID, _ := fields[1].([]byte)
+	number, err := strconv.ParseUint(string(block), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid event checkpoint of %s: %w", target.Name, err)
+	}
+	checkpointer := new(client.InMemoryCheckpointer)
+	checkpointer.CheckpointTransaction(number, string(txID))
+	return checkpointer, nil
+}
+
+func saveEventCheckpoint(ctx context.Context, target *fabricTarget, event *client.ChaincodeEvent) error {
+	_, err := documentCache.Do(ctx, "HSET", eventCheckpointPrefix+target.Name,
+		"block", strconv.FormatUint(event.BlockNumber, 10), "tx_id", event.TransactionID)
+	return err
+}
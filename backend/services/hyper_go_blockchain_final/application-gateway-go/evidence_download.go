@@ -0,0 +1,128 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const errCodeEvidenceTampered = "EVIDENCE_HASH_MISMATCH"
+
+// hashStoredObject streams an object out of the evidence store and returns its SHA-256
+func hashStoredObject(ctx context.Context, key string) (string, error) {
+	return hashStoredPlaintext(ctx, key, storedAsIs)
+}
+
+// storedAsIs reads a file that is not encrypted
+func storedAsIs(r io.Reader) io.Reader { return r }
+
+// hashStoredPlaintext returns the SHA-256 of an object as read through plaintext, which decrypts
+// encrypted evidence
+func hashStoredPlaintext(ctx context.Context, key string, plaintext func(io.Reader) io.Reader) (string, error) {
+	body, err := evidenceStore.Get(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, plaintext(body)); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// downloadEvidence re-hashes the stored file and only releases it when it matches the anchored hash.
+// Encrypted files are decrypted by the gateway for callers allowed to, and never presigned.
+func downloadEvidence(c *gin.Context) {
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+
+	result, err := evaluateTransaction(ctx, "ReadEvidence", id)
+	if err != nil {
+		respondFabricError(c, "Failed to read evidence", err)
+		return
+	}
+
+	var evidence EvidenceDocument
+	if err := json.Unmarshal(result, &evidence); err != nil {
+		respondError(c, http.StatusInternalServerError, errCodeInternal, "Failed to parse evidence data")
+		return
+	}
+
+	key := evidenceObjectKey(evidence.IncidentID, id)
+	plaintext := storedAsIs
+	if evidence.EncryptionKeyRef != "" {
+		if plaintext, ok = evidencePlaintext(c, evidence); !ok {
+			return
+		}
+	}
+	storedHash, err := hashStoredPlaintext(ctx, key, plaintext)
+	if errors.Is(err, errObjectNotFound) {
+		respondError(c, http.StatusNotFound, errCodeNotFound, fmt.Sprintf("No stored file for evidence %s", id))
+		return
+	}
+	if errors.Is(err, errEvidenceUndecryptable) {
+		logWithContext(ctx, "⚠️  Evidence %s does not decrypt with %s", id, evidence.EncryptionKeyRef)
+		respondError(c, http.StatusConflict, errCodeEvidenceTampered, "Stored evidence file does not decrypt with its data key")
+		return
+	}
+	if err != nil {
+		logWithContext(ctx, "Failed to read evidence file %s: %v", key, err)
+		respondError(c, http.StatusServiceUnavailable, errCodeUnavailable, "Failed to read evidence file from storage")
+		return
+	}
+
+	if storedHash != evidence.EvidenceHash {
+		logWithContext(ctx, "⚠️  Evidence %s hash mismatch: ledger=%s stored=%s", id, evidence.EvidenceHash, storedHash)
+		respondError(c, http.StatusConflict, errCodeEvidenceTampered, "Stored evidence file does not match the hash anchored on the ledger")
+		return
+	}
+
+	if presigner, ok := evidenceStore.(Presigner); ok && evidence.EncryptionKeyRef == "" {
+		ttl := getEnvDuration("EVIDENCE_URL_TTL", 5*time.Minute)
+		url, err := presigner.PresignGet(key, ttl)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, errCodeInternal, fmt.Sprintf("Failed to presign download URL: %v", err))
+			return
+		}
+		respondData(c, http.StatusOK, gin.H{
+			"evidenceID":   id,
+			"evidenceHash": evidence.EvidenceHash,
+			"verified":     true,
+			"url":          url,
+			"expiresAt":    time.Now().Add(ttl).UTC().Format(time.RFC3339),
+		})
+		return
+	}
+
+	// Backends without presigning serve the verified file directly
+	body, err := evidenceStore.Get(ctx, key)
+	if err != nil {
+		respondError(c, http.StatusServiceUnavailable, errCodeUnavailable, "Failed to read evidence file from storage")
+		return
+	}
+	defer body.Close()
+
+	c.DataFromReader(http.StatusOK, -1, evidence.MediaType, plaintext(body), map[string]string{
+		"Content-Disposition": fmt.Sprintf(`attachment; filename="%s"`, id),
+		"X-Evidence-Hash":     evidence.EvidenceHash,
+	})
+}
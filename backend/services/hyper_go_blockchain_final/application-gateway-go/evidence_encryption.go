@@ -0,0 +1,779 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	evidenceKeyPrefix = "evidence-keys/"
+	// evidenceSegmentSize is how much plaintext each sealed segment of an encrypted file holds
+	evidenceSegmentSize = 64 * 1024
+	// evidenceCipherMagic starts every encrypted file, followed by the file's nonce prefix
+	evidenceCipherMagic     = "SIHE\x01"
+	evidenceNoncePrefixSize = 7
+	// evidenceKeyContext is bound to every wrapped data key, so the master key cannot be used to
+	// unwrap them for anything else
+	evidenceKeyContext = "sih-evidence-data-key"
+
+	errCodeEvidenceKeyUnavailable = "EVIDENCE_KEY_UNAVAILABLE"
+)
+
+var (
+	// errEvidenceUndecryptable is returned while reading an encrypted file that was altered,
+	// truncated or encrypted under another key
+	errEvidenceUndecryptable = errors.New("evidence file does not decrypt with its data key")
+	errEvidenceDecryptDenied = errors.New("caller may not decrypt this incident's evidence")
+)
+
+// masterKey wraps evidence data keys. id names the key new data keys are wrapped with; unwrap is
+// given the id recorded with a wrapped key, so keys wrapped before a rotation still open.
+type masterKey interface {
+	id() string
+	wrap(ctx context.Context, dataKey []byte) ([]byte, error)
+	unwrap(ctx context.Context, masterKeyID string, wrapped []byte) ([]byte, error)
+}
+
+// localMasterKey wraps data keys with AES-256-GCM keys from the environment. Previous keys only
+// unwrap, until every data key has been rewrapped under the current one.
+type localMasterKey struct {
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+func newLocalMasterKey(current string, previous []string) (*localMasterKey, error) {
+	m := &localMasterKey{keys: map[string]cipher.AEAD{}}
+	for i, encoded := range append([]string{current}, previous...) {
+		raw, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(raw) != 32 {
+			return nil, errors.New("master keys must be 32 bytes, base64-encoded")
+		}
+		aead, err := newEvidenceAEAD(raw)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(raw)
+		id := "local:" + hex.EncodeToString(sum[:8])
+		if i == 0 {
+			m.current = id
+		}
+		m.keys[id] = aead
+	}
+	return m, nil
+}
+
+func (m *localMasterKey) id() string { return m.current }
+
+func (m *localMasterKey) wrap(_ context.Context, dataKey []byte) ([]byte, error) {
+	aead := m.keys[m.current]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, dataKey, []byte(evidenceKeyContext)), nil
+}
+
+func (m *localMasterKey) unwrap(_ context.Context, masterKeyID string, wrapped []byte) ([]byte, error) {
+	aead, ok := m.keys[masterKeyID]
+	if !ok {
+		return nil, fmt.Errorf("master key %s is not configured", masterKeyID)
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, errors.New("wrapped data key is too short")
+	}
+	return aead.Open(nil, wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():], []byte(evidenceKeyContext))
+}
+
+// AWS KMS wraps data keys with Encrypt and Decrypt under a symmetric key
+
+func (k *awsKMS) id() string { return k.keyID }
+
+func (k *awsKMS) wrap(ctx context.Context, dataKey []byte) ([]byte, error) {
+	ctx, cancel := kmsContext(ctx, k.timeout)
+	defer cancel()
+	out, err := k.client.Encrypt(ctx, &kms.EncryptInput{
+		KeyId:             aws.String(k.keyID),
+		Plaintext:         dataKey,
+		EncryptionContext: map[string]string{"purpose": evidenceKeyContext},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.CiphertextBlob, nil
+}
+
+func (k *awsKMS) unwrap(ctx context.Context, masterKeyID string, wrapped []byte) ([]byte, error) {
+	ctx, cancel := kmsContext(ctx, k.timeout)
+	defer cancel()
+	out, err := k.client.Decrypt(ctx, &kms.DecryptInput{
+		KeyId:             aws.String(masterKeyID),
+		CiphertextBlob:    wrapped,
+		EncryptionContext: map[string]string{"purpose": evidenceKeyContext},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Plaintext, nil
+}
+
+// kmsContext bounds a KMS call by KMS_TIMEOUT, when one is configured
+func kmsContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// Cloud KMS wraps data keys with a symmetric crypto key, named without a version so that Cloud KMS
+// picks the primary version to encrypt and the right one to decrypt
+
+func (k *gcpKMS) id() string { return k.keyName }
+
+func (k *gcpKMS) wrap(ctx context.Context, dataKey []byte) ([]byte, error) {
+	ctx, cancel := kmsContext(ctx, k.timeout)
+	defer cancel()
+	resp, err := k.client.Encrypt(ctx, &kmspb.EncryptRequest{Name: k.keyName, Plaintext: dataKey, AdditionalAuthenticatedData: []byte(evidenceKeyContext)})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Ciphertext, nil
+}
+
+func (k *gcpKMS) unwrap(ctx context.Context, masterKeyID string, wrapped []byte) ([]byte, error) {
+	ctx, cancel := kmsContext(ctx, k.timeout)
+	defer cancel()
+	resp, err := k.client.Decrypt(ctx, &kmspb.DecryptRequest{Name: masterKeyID, Ciphertext: wrapped, AdditionalAuthenticatedData: []byte(evidenceKeyContext)})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Plaintext, nil
+}
+
+func newEvidenceAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// evidenceDataKey is an incident's data key as stored beside its evidence, wrapped by the master
+// key. KeyRef, "<incident>/<key id>", is what evidence documents on the ledger record.
+type evidenceDataKey struct {
+	KeyRef      string `json:"key_ref"`
+	IncidentID  string `json:"incident_id"`
+	MasterKey   string `json:"master_key"`
+	WrappedKey  []byte `json:"wrapped_key"`
+	CreatedAt   string `json:"created_at"`
+	RewrappedAt string `json:"rewrapped_at,omitempty"`
+}
+
+type cachedDataKey struct {
+	aead    cipher.AEAD
+	expires time.Time
+}
+
+// evidenceKeyring creates, unwraps and rewraps per-incident data keys. Wrapped keys live in the
+// evidence store under evidence-keys/, with a pointer to each incident's current key.
+type evidenceKeyring struct {
+	store    EvidenceStore
+	master   masterKey
+	cacheTTL time.Duration
+
+	mu    sync.Mutex
+	cache map[string]cachedDataKey
+}
+
+var evidenceKeys *evidenceKeyring
+
+// initEvidenceEncryption selects the master key from EVIDENCE_ENCRYPTION. Runs after
+// initEvidenceStore.
+func initEvidenceEncryption() {
+	var master masterKey
+	switch kind := getEnv("EVIDENCE_ENCRYPTION", ""); kind {
+	case "":
+		log.Println("🔐 EVIDENCE_ENCRYPTION not set, evidence files are stored unencrypted")
+		return
+	case "local":
+		var previous []string
+		for _, key := range strings.Split(getEnv("EVIDENCE_PREVIOUS_MASTER_KEYS", ""), ",") {
+			if key = strings.TrimSpace(key); key != "" {
+				previous = append(previous, key)
+			}
+		}
+		local, err := newLocalMasterKey(getEnv("EVIDENCE_MASTER_KEY", ""), previous)
+		if err != nil {
+			panic(fmt.Errorf("EVIDENCE_MASTER_KEY: %w", err))
+		}
+		master = local
+	case "aws-kms", "gcp-kms":
+		client, err := newKMSClient(kind, "EVIDENCE_KMS_KEY_ID")
+		if err != nil {
+			panic(fmt.Errorf("failed to configure evidence encryption with %s: %w", kind, err))
+		}
+		master = client
+	default:
+		panic(fmt.Errorf("unknown EVIDENCE_ENCRYPTION %q, expected local, aws-kms or gcp-kms", kind))
+	}
+
+	evidenceKeys = newEvidenceKeyring(evidenceStore, master, getEnvDuration("EVIDENCE_KEY_CACHE_TTL", 5*time.Minute))
+	log.Printf("🔐 Encrypting evidence files with per-incident data keys wrapped by %s", master.id())
+}
+
+func newEvidenceKeyring(store EvidenceStore, master masterKey, cacheTTL time.Duration) *evidenceKeyring {
+	return &evidenceKeyring{store: store, master: master, cacheTTL: cacheTTL, cache: map[string]cachedDataKey{}}
+}
+
+func evidenceCurrentKeyPath(incidentID string) string {
+	return evidenceKeyPrefix + incidentID + "/current"
+}
+
+// current returns the incident's current data key, creating one for its first encrypted file
+func (k *evidenceKeyring) current(ctx context.Context, incidentID string) (string, cipher.AEAD, error) {
+	body, err := k.store.Get(ctx, evidenceCurrentKeyPath(incidentID))
+	if errors.Is(err, errObjectNotFound) {
+		return k.create(ctx, incidentID)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	ref, err := io.ReadAll(io.LimitReader(body, 256))
+	body.Close()
+	if err != nil {
+		return "", nil, err
+	}
+	aead, err := k.open(ctx, string(ref))
+	return string(ref), aead, err
+}
+
+// create adds a data key for the incident and makes it current. Keys get random IDs, so gateways
+// creating one at the same time each keep their own and only the pointer is overwritten.
+func (k *evidenceKeyring) create(ctx context.Context, incidentID string) (string, cipher.AEAD, error) {
+	raw := make([]byte, 32+8)
+	if _, err := rand.Read(raw); err != nil {
+		return "", nil, err
+	}
+	dataKey, keyID := raw[:32], hex.EncodeToString(raw[32:])
+	wrapped, err := k.master.wrap(ctx, dataKey)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to wrap data key: %w", err)
+	}
+	key := evidenceDataKey{
+		KeyRef:     incidentID + "/" + keyID,
+		IncidentID: incidentID,
+		MasterKey:  k.master.id(),
+		WrappedKey: wrapped,
+		CreatedAt:  time.Now().UTC().Format(time.RFC3339),
+	}
+	if err := k.save(ctx, key); err != nil {
+		return "", nil, err
+	}
+	if err := k.store.Put(ctx, evidenceCurrentKeyPath(incidentID), strings.NewReader(key.KeyRef), int64(len(key.KeyRef)), "text/plain"); err != nil {
+		return "", nil, err
+	}
+	aead, err := newEvidenceAEAD(dataKey)
+	if err != nil {
+		return "", nil, err
+	}
+	k.remember(key.KeyRef, aead)
+	return key.KeyRef, aead, nil
+}
+
+func (k *evidenceKeyring) load(ctx context.Context, keyRef string) (*evidenceDataKey, error) {
+	body, err := k.store.Get(ctx, evidenceKeyPrefix+keyRef)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	var key evidenceDataKey
+	if err := json.NewDecoder(body).Decode(&key); err != nil {
+		return nil, &malformedDocumentError{kind: "evidence data key", err: err}
+	}
+	return &key, nil
+}
+
+func (k *evidenceKeyring) save(ctx context.Context, key evidenceDataKey) error {
+	data, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+	return k.store.Put(ctx, evidenceKeyPrefix+key.KeyRef, bytes.NewReader(data), int64(len(data)), "application/json")
+}
+
+// open unwraps a data key, keeping it for cacheTTL so downloads do not each call the KMS
+func (k *evidenceKeyring) open(ctx context.Context, keyRef string) (cipher.AEAD, error) {
+	k.mu.Lock()
+	cached, ok := k.cache[keyRef]
+	k.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.aead, nil
+	}
+
+	key, err := k.load(ctx, keyRef)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load data key %s: %w", keyRef, err)
+	}
+	dataKey, err := k.master.unwrap(ctx, key.MasterKey, key.WrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key %s: %w", keyRef, err)
+	}
+	aead, err := newEvidenceAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	k.remember(keyRef, aead)
+	return aead, nil
+}
+
+func (k *evidenceKeyring) remember(keyRef string, aead cipher.AEAD) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	now := time.Now()
+	for ref, cached := range k.cache {
+		if now.After(cached.expires) {
+			delete(k.cache, ref)
+		}
+	}
+	k.cache[keyRef] = cachedDataKey{aead: aead, expires: now.Add(k.cacheTTL)}
+}
+
+// rewrap wraps a data key under the current master key, reporting whether it had to. The data key
+// itself, and so every file encrypted with it, is unchanged.
+func (k *evidenceKeyring) rewrap(ctx context.Context, keyRef string) (bool, error) {
+	key, err := k.load(ctx, keyRef)
+	if err != nil {
+		return false, err
+	}
+	if key.MasterKey == k.master.id() {
+		return false, nil
+	}
+	dataKey, err := k.master.unwrap(ctx, key.MasterKey, key.WrappedKey)
+	if err != nil {
+		return false, err
+	}
+	if key.WrappedKey, err = k.master.wrap(ctx, dataKey); err != nil {
+		return false, err
+	}
+	key.MasterKey, key.RewrappedAt = k.master.id(), time.Now().UTC().Format(time.RFC3339)
+	return true, k.save(ctx, *key)
+}
+
+// Encrypted files are sealed in segments (the STREAM construction): each segment's nonce is the
+// file's random prefix, the segment number and a flag marking the last segment, so segments cannot
+// be reordered, dropped or cut off without failing to open. The object key is the associated data,
+// which stops a file being swapped for another evidence file encrypted under the same key.
+
+func evidenceSegmentNonce(prefix []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, 0, evidenceNoncePrefixSize+5)
+	nonce = append(nonce, prefix...)
+	nonce = binary.BigEndian.AppendUint32(nonce, counter)
+	if last {
+		return append(nonce, 1)
+	}
+	return append(nonce, 0)
+}
+
+// segmentReader splits a stream into segments, telling the last one by reading a byte ahead
+type segmentReader struct {
+	r     io.Reader
+	ahead []byte
+}
+
+func (s *segmentReader) next(buf []byte) (int, bool, error) {
+	n := copy(buf, s.ahead)
+	s.ahead = s.ahead[:0]
+	m, err := io.ReadFull(s.r, buf[n:])
+	n += m
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return n, true, nil
+	}
+	if err != nil {
+		return n, false, err
+	}
+	var one [1]byte
+	if _, err := io.ReadFull(s.r, one[:]); err == io.EOF {
+		return n, true, nil
+	} else if err != nil {
+		return n, false, err
+	}
+	s.ahead = append(s.ahead, one[0])
+	return n, false, nil
+}
+
+// encryptingReader reads plaintext from src and returns the encrypted file
+type encryptingReader struct {
+	src     segmentReader
+	aead    cipher.AEAD
+	aad     []byte
+	prefix  []byte
+	counter uint32
+	plain   []byte
+	out     []byte
+	done    bool
+}
+
+func newEncryptingReader(src io.Reader, aead cipher.AEAD, objectKey string) (io.Reader, error) {
+	prefix := make([]byte, evidenceNoncePrefixSize)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, err
+	}
+	return &encryptingReader{
+		src:    segmentReader{r: src},
+		aead:   aead,
+		aad:    []byte(objectKey),
+		prefix: prefix,
+		plain:  make([]byte, evidenceSegmentSize),
+		out:    append([]byte(evidenceCipherMagic), prefix...),
+	}, nil
+}
+
+func (e *encryptingReader) Read(p []byte) (int, error) {
+	for len(e.out) == 0 {
+		if e.done {
+			return 0, io.EOF
+		}
+		n, last, err := e.src.next(e.plain)
+		if err != nil {
+			return 0, err
+		}
+		e.out = e.aead.Seal(e.out[:0], evidenceSegmentNonce(e.prefix, e.counter, last), e.plain[:n], e.aad)
+		e.counter++
+		e.done = last
+	}
+	n := copy(p, e.out)
+	e.out = e.out[n:]
+	return n, nil
+}
+
+// decryptingReader reads an encrypted file from src and returns the plaintext, failing with
+// errEvidenceUndecryptable at the first segment that does not open
+type decryptingReader struct {
+	src     segmentReader
+	aead    cipher.AEAD
+	aad     []byte
+	prefix  []byte
+	counter uint32
+	sealed  []byte
+	out     []byte
+	done    bool
+}
+
+func newDecryptingReader(src io.Reader, aead cipher.AEAD, objectKey string) io.Reader {
+	return &decryptingReader{
+		src:    segmentReader{r: src},
+		aead:   aead,
+		aad:    []byte(objectKey),
+		sealed: make([]byte, evidenceSegmentSize+aead.Overhead()),
+	}
+}
+
+func (d *decryptingReader) Read(p []byte) (int, error) {
+	if d.prefix == nil {
+		header := make([]byte, len(evidenceCipherMagic)+evidenceNoncePrefixSize)
+		if _, err := io.ReadFull(d.src.r, header); err != nil || string(header[:len(evidenceCipherMagic)]) != evidenceCipherMagic {
+			return 0, errEvidenceUndecryptable
+		}
+		d.prefix = header[len(evidenceCipherMagic):]
+	}
+	for len(d.out) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		n, last, err := d.src.next(d.sealed)
+		if err != nil {
+			return 0, err
+		}
+		d.out, err = d.aead.Open(d.out[:0], evidenceSegmentNonce(d.prefix, d.counter, last), d.sealed[:n], d.aad)
+		if err != nil {
+			return 0, errEvidenceUndecryptable
+		}
+		d.counter++
+		d.done = last
+	}
+	n := copy(p, d.out)
+	d.out = d.out[n:]
+	return n, nil
+}
+
+// authorizeEvidenceDecrypt lets a caller decrypt an incident's evidence when the access policy
+// grants "decrypt_any" on evidence, or grants "decrypt" and one of the caller's roles is
+// "unit:<id>" for a unit assigned to the incident that has not been escalated or declined. Without an access
+// policy every caller may decrypt.
+func authorizeEvidenceDecrypt(ctx context.Context, incidentID string) error {
+	if access == nil {
+		return nil
+	}
+	subjects, org := callerSubjects(ctx)
+	policy := access.current()
+	if policy.allows(subjects, org, "evidence", actionDecryptAny) {
+		return nil
+	}
+	if !policy.allows(subjects, org, "evidence", actionDecrypt) {
+		return errEvidenceDecryptDenied
+	}
+
+	assignments, err := ledger.ListAssignments(ctx, AssignmentListRequest{Subject: incidentID})
+	if err != nil {
+		return err
+	}
+	roles := policy.roles(subjects)
+	for _, assignment := range assignments {
+		if (assignment.Status == "assigned" || assignment.Status == "acknowledged") && slices.Contains(roles, "unit:"+assignment.UnitID) {
+			return nil
+		}
+	}
+	return errEvidenceDecryptDenied
+}
+
+// evidencePlaintext checks that the caller may decrypt the evidence and returns the function that
+// decrypts its stored file, responding with an error when it cannot
+func evidencePlaintext(c *gin.Context, evidence EvidenceDocument) (func(io.Reader) io.Reader, bool) {
+	return evidenceObjectPlaintext(c, evidence.IncidentID, evidence.EncryptionKeyRef, evidenceObjectKey(evidence.IncidentID, evidence.EvidenceID))
+}
+
+// evidenceObjectPlaintext does the same for any object encrypted under one of an incident's data
+// keys, such as a copy derived from its evidence
+func evidenceObjectPlaintext(c *gin.Context, incidentID, keyRef, objectKey string) (func(io.Reader) io.Reader, bool) {
+	ctx := c.Request.Context()
+	if err := authorizeEvidenceDecrypt(ctx, incidentID); errors.Is(err, errEvidenceDecryptDenied) {
+		respondError(c, http.StatusForbidden, errCodeAccessDenied, "Decrypting this evidence needs the decrypt permission and an assignment to the incident")
+		return nil, false
+	} else if err != nil {
+		respondServiceError(c, "Failed to check incident assignments", err)
+		return nil, false
+	}
+	if evidenceKeys == nil {
+		respondError(c, http.StatusServiceUnavailable, errCodeEvidenceKeyUnavailable, "Evidence is encrypted but EVIDENCE_ENCRYPTION is not configured")
+		return nil, false
+	}
+	aead, err := evidenceKeys.open(ctx, keyRef)
+	if err != nil {
+		logWithContext(ctx, "Failed to open data key for %s: %v", objectKey, err)
+		respondError(c, http.StatusServiceUnavailable, errCodeEvidenceKeyUnavailable, "Failed to unwrap the evidence data key")
+		return nil, false
+	}
+	return func(r io.Reader) io.Reader { return newDecryptingReader(r, aead, objectKey) }, true
+}
+
+// Key rotation
+
+// EvidenceKeyRewrap reports rewrapping every data key under the current master key
+type EvidenceKeyRewrap struct {
+	MasterKey string   `json:"master_key"`
+	Keys      int      `json:"keys"`
+	Rewrapped int      `json:"rewrapped"`
+	Failed    []string `json:"failed"`
+}
+
+// RotateEvidenceKeyRequest names who re-encrypts an incident's evidence under a new data key
+type RotateEvidenceKeyRequest struct {
+	Actor string `json:"actor" binding:"required"`
+}
+
+func (r RotateEvidenceKeyRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.identifier("actor", r.Actor)
+	return v.errors
+}
+
+// EvidenceKeyRotation reports re-encrypting an incident's evidence under a new data key
+type EvidenceKeyRotation struct {
+	IncidentID string   `json:"incident_id"`
+	KeyRef     string   `json:"key_ref"`
+	Rekeyed    []string `json:"rekeyed"`
+	Failed     []string `json:"failed"`
+}
+
+// rewrapAll rewraps the data key of every encrypted evidence document on the ledger. Run it after
+// changing the master key, before retiring the previous one.
+func (k *evidenceKeyring) rewrapAll(ctx context.Context) (*EvidenceKeyRewrap, error) {
+	result := &EvidenceKeyRewrap{MasterKey: k.master.id(), Failed: []string{}}
+	seen := map[string]bool{}
+	bookmark := ""
+	for {
+		page, err := ledger.ExportDocuments(ctx, "evidence", bookmark)
+		if err != nil {
+			return nil, err
+		}
+		for _, doc := range page.Documents {
+			var evidence EvidenceDocument
+			if json.Unmarshal(doc, &evidence) != nil || evidence.EncryptionKeyRef == "" || seen[evidence.EncryptionKeyRef] {
+				continue
+			}
+			seen[evidence.EncryptionKeyRef] = true
+			result.Keys++
+			rewrapped, err := k.rewrap(ctx, evidence.EncryptionKeyRef)
+			if err != nil {
+				logWithContext(ctx, "🔐 Failed to rewrap data key %s: %v", evidence.EncryptionKeyRef, err)
+				result.Failed = append(result.Failed, evidence.EncryptionKeyRef)
+			} else if rewrapped {
+				result.Rewrapped++
+			}
+		}
+		if page.Bookmark == "" {
+			return result, nil
+		}
+		bookmark = page.Bookmark
+	}
+}
+
+// rotate gives an incident a new data key and re-encrypts its evidence under it. Each file is
+// decrypted to a spool file and checked against its anchored hash before it is replaced, and is put
+// back under its old key if the ledger does not accept the new key reference.
+func (k *evidenceKeyring) rotate(ctx context.Context, incidentID string, req RotateEvidenceKeyRequest) (*EvidenceKeyRotation, error) {
+	if err := validateMutation(incidentID, req); err != nil {
+		return nil, err
+	}
+	evidence, err := evidenceByIncidentFromLedger(ctx, incidentID, EvidenceListRequest{})
+	if err != nil {
+		return nil, err
+	}
+	keyRef, aead, err := k.create(ctx, incidentID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &EvidenceKeyRotation{IncidentID: incidentID, KeyRef: keyRef, Rekeyed: []string{}, Failed: []string{}}
+	for _, e := range evidence {
+		if e.EncryptionKeyRef == "" || e.EncryptionKeyRef == keyRef {
+			continue
+		}
+		if err := k.rekey(ctx, e, keyRef, aead, req.Actor); err != nil {
+			logWithContext(ctx, "🔐 Failed to re-encrypt evidence %s: %v", e.EvidenceID, err)
+			result.Failed = append(result.Failed, e.EvidenceID)
+			continue
+		}
+		result.Rekeyed = append(result.Rekeyed, e.EvidenceID)
+	}
+	return result, nil
+}
+
+func (k *evidenceKeyring) rekey(ctx context.Context, evidence EvidenceDocument, keyRef string, aead cipher.AEAD, actor string) error {
+	oldAEAD, err := k.open(ctx, evidence.EncryptionKeyRef)
+	if err != nil {
+		return err
+	}
+	objectKey := evidenceObjectKey(evidence.IncidentID, evidence.EvidenceID)
+
+	spool, err := os.CreateTemp(uploads.spoolDir, "rekey-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+	body, err := k.store.Get(ctx, objectKey)
+	if err != nil {
+		return err
+	}
+	hasher := sha256.New()
+	_, err = io.Copy(io.MultiWriter(spool, hasher), newDecryptingReader(body, oldAEAD, objectKey))
+	body.Close()
+	if err != nil {
+		return err
+	}
+	if hex.EncodeToString(hasher.Sum(nil)) != evidence.EvidenceHash {
+		return errors.New("stored file does not match the anchored hash")
+	}
+
+	// put encrypts the spooled plaintext under a data key and stores it, returning its CID when the
+	// store is content-addressed
+	put := func(aead cipher.AEAD) (string, error) {
+		if _, err := spool.Seek(0, io.SeekStart); err != nil {
+			return "", err
+		}
+		encrypted, err := newEncryptingReader(spool, aead, objectKey)
+		if err != nil {
+			return "", err
+		}
+		if err := k.store.Put(ctx, objectKey, encrypted, -1, "application/octet-stream"); err != nil {
+			return "", err
+		}
+		if addressed, ok := k.store.(ContentAddressed); ok {
+			return addressed.ContentID(ctx, objectKey)
+		}
+		return "", nil
+	}
+	cid, err := put(aead)
+	if err != nil {
+		return err
+	}
+	if _, err := submitAndInvalidate(ctx, evidence.EvidenceID, "RekeyEvidence", evidence.EvidenceID, keyRef, cid, actor); err != nil {
+		if _, restoreErr := put(oldAEAD); restoreErr != nil {
+			logWithContext(ctx, "⚠️  Evidence %s is encrypted under %s but the ledger still names %s: %v", evidence.EvidenceID, keyRef, evidence.EncryptionKeyRef, restoreErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// requireEvidenceKeys reports whether evidence encryption is enabled, responding 404 when it is not
+func requireEvidenceKeys(c *gin.Context) bool {
+	if evidenceKeys == nil {
+		respondError(c, http.StatusNotFound, errCodeNotFound, "Evidence encryption is not enabled; set EVIDENCE_ENCRYPTION")
+		return false
+	}
+	return true
+}
+
+func rewrapEvidenceKeys(c *gin.Context) {
+	if !requireEvidenceKeys(c) {
+		return
+	}
+	result, err := evidenceKeys.rewrapAll(c.Request.Context())
+	if err != nil {
+		respondServiceError(c, "Failed to rewrap evidence data keys", err)
+		return
+	}
+	respondData(c, http.StatusOK, result)
+}
+
+func rotateEvidenceKey(c *gin.Context) {
+	if !requireEvidenceKeys(c) {
+		return
+	}
+	id, ok := validPathID(c, "incidentId")
+	if !ok {
+		return
+	}
+	var req RotateEvidenceKeyRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+	setAuditTarget(c, id)
+
+	result, err := evidenceKeys.rotate(c.Request.Context(), id, req)
+	if err != nil {
+		respondServiceError(c, "Failed to rotate the evidence data key", err)
+		return
+	}
+	respondData(c, http.StatusOK, result)
+}
@@ -0,0 +1,138 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func testMasterKey(t *testing.T, previous ...string) (*localMasterKey, string) {
+	raw := make([]byte, 32)
+	rand.Read(raw)
+	encoded := base64.StdEncoding.EncodeToString(raw)
+	master, err := newLocalMasterKey(encoded, previous)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return master, encoded
+}
+
+func TestEvidenceStreamCipher(t *testing.T) {
+	aead, _ := newEvidenceAEAD(bytes.Repeat([]byte{7}, 32))
+	const objectKey = "evidence/inc_1/ev_1"
+
+	encrypt := func(plain []byte) []byte {
+		r, err := newEncryptingReader(bytes.NewReader(plain), aead, objectKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sealed, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sealed
+	}
+	decrypt := func(sealed []byte, key string) ([]byte, error) {
+		return io.ReadAll(newDecryptingReader(bytes.NewReader(sealed), aead, key))
+	}
+
+	for _, size := range []int{0, 1, evidenceSegmentSize, evidenceSegmentSize + 1, 3 * evidenceSegmentSize} {
+		plain := make([]byte, size)
+		rand.Read(plain)
+		sealed := encrypt(plain)
+		segments := size/evidenceSegmentSize + 1
+		if size > 0 && size%evidenceSegmentSize == 0 {
+			segments--
+		}
+		if want := len(evidenceCipherMagic) + evidenceNoncePrefixSize + size + segments*aead.Overhead(); len(sealed) != want {
+			t.Errorf("%d bytes: expected %d encrypted bytes, got %d", size, want, len(sealed))
+		}
+		got, err := decrypt(sealed, objectKey)
+		if err != nil || !bytes.Equal(got, plain) {
+			t.Fatalf("%d bytes: round trip failed: %v", size, err)
+		}
+	}
+
+	plain := make([]byte, 2*evidenceSegmentSize+10)
+	sealed := encrypt(plain)
+	tampered := bytes.Clone(sealed)
+	tampered[len(tampered)/2] ^= 1
+	truncated := sealed[:len(evidenceCipherMagic)+evidenceNoncePrefixSize+evidenceSegmentSize+aead.Overhead()]
+	for name, tt := range map[string]struct {
+		sealed []byte
+		key    string
+	}{
+		"tampered":          {tampered, objectKey},
+		"truncated":         {truncated, objectKey},
+		"other object":      {sealed, "evidence/inc_1/ev_2"},
+		"not a cipher file": {plain, objectKey},
+	} {
+		if _, err := decrypt(tt.sealed, tt.key); !errors.Is(err, errEvidenceUndecryptable) {
+			t.Errorf("%s: expected errEvidenceUndecryptable, got %v", name, err)
+		}
+	}
+}
+
+func TestEvidenceKeyring(t *testing.T) {
+	ctx := context.Background()
+	store, err := newLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldMaster, oldEncoded := testMasterKey(t)
+	keys := newEvidenceKeyring(store, oldMaster, time.Minute)
+
+	ref, aead, err := keys.current(ctx, "inc_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, _, err := keys.current(ctx, "inc_1")
+	if err != nil || again != ref {
+		t.Fatalf("expected the incident's current key %s, got %s: %v", ref, again, err)
+	}
+	sealed := aead.Seal(nil, make([]byte, 12), []byte("statement"), nil)
+
+	// A restarted gateway with a new master key still opens keys wrapped by the previous one
+	newMaster, _ := testMasterKey(t, oldEncoded)
+	rotated := newEvidenceKeyring(store, newMaster, time.Minute)
+	if rewrapped, err := rotated.rewrap(ctx, ref); err != nil || !rewrapped {
+		t.Fatalf("expected the key rewrapped, got %v %v", rewrapped, err)
+	}
+	if rewrapped, err := rotated.rewrap(ctx, ref); err != nil || rewrapped {
+		t.Errorf("expected a rewrapped key left alone, got %v %v", rewrapped, err)
+	}
+
+	newOnly := &localMasterKey{current: newMaster.current, keys: map[string]cipher.AEAD{newMaster.current: newMaster.keys[newMaster.current]}}
+	opened, err := newEvidenceKeyring(store, newOnly, time.Minute).open(ctx, ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain, err := opened.Open(nil, make([]byte, 12), sealed, nil); err != nil || string(plain) != "statement" {
+		t.Errorf("expected the same data key after rewrapping, got %q %v", plain, err)
+	}
+	if _, err := newEvidenceKeyring(store, oldMaster, time.Minute).open(ctx, ref); err == nil {
+		t.Error("expected the retired master key unable to unwrap")
+	}
+}
+
+func TestAuthorizeEvidenceDecrypt(t *testing.T) {
+	policy, err := parseAccessPolicy("p, forensics, evidence, decrypt_any\np, reader, evidence, read\ng, wallet:lab, forensics\ng, wallet:clerk, reader\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	access = &accessControl{policy: policy}
+	defer func() { access = nil }()
+
+	if err := authorizeEvidenceDecrypt(withSigner(context.Background(), &walletIdentity{Label: "lab", MSPID: "Org1MSP"}), "inc_1"); err != nil {
+		t.Errorf("expected decrypt_any to allow any incident, got %v", err)
+	}
+	if err := authorizeEvidenceDecrypt(withSigner(context.Background(), &walletIdentity{Label: "clerk", MSPID: "Org1MSP"}), "inc_1"); !errors.Is(err, errEvidenceDecryptDenied) {
+		t.Errorf("expected a reader denied, got %v", err)
+	}
+}
@@ -0,0 +1,650 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	errCodeMediaPipelineDisabled = "MEDIA_PIPELINE_DISABLED"
+
+	derivativeThumbnail = "thumbnail"
+	derivativePreview   = "preview"
+	derivativeSanitized = "sanitized"
+
+	mediaPipelineActor = "media-pipeline"
+	// maxThumbnailPixels bounds the images decoded in the gateway, against decompression bombs
+	maxThumbnailPixels = 64 << 20
+	thumbnailQuality   = 80
+)
+
+var derivativeKinds = []string{derivativeThumbnail, derivativePreview, derivativeSanitized}
+
+// ffmpegFormats are the ffmpeg muxers that rewrite each audio and video evidence type as is
+var ffmpegFormats = map[string]string{
+	"video/mp4":       "mp4",
+	"video/quicktime": "mov",
+	"audio/mpeg":      "mp3",
+	"audio/mp4":       "ipod",
+	"audio/wav":       "wav",
+}
+
+// EvidenceDerivative is a copy derived from an evidence file, as the chaincode anchors it
+type EvidenceDerivative struct {
+	DocType          string `json:"doc_type"`
+	DerivativeID     string `json:"derivative_id"`
+	EvidenceID       string `json:"evidence_id"`
+	IncidentID       string `json:"incident_id"`
+	Kind             string `json:"kind"`
+	ParentHash       string `json:"parent_hash"`
+	DerivativeHash   string `json:"derivative_hash"`
+	MediaType        string `json:"media_type"`
+	Transformation   string `json:"transformation"`
+	EncryptionKeyRef string `json:"encryption_key_ref,omitempty"`
+	CreatedBy        string `json:"created_by"`
+	CreatedAt        string `json:"created_at"`
+	OwnerOrg         string `json:"owner_org,omitempty"`
+	TxID             string `json:"tx_id"`
+	// Stale is set when the evidence has been updated since the copy was made from it
+	Stale bool `json:"stale,omitempty"`
+}
+
+// mediaPipeline derives thumbnails, dashboard previews and metadata-stripped copies from uploaded
+// evidence. Originals are only read: every copy is stored beside them and anchored with the hash of
+// the original it was made from.
+type mediaPipeline struct {
+	// ffmpeg is the path of the ffmpeg binary; without it only JPEG and PNG images are processed
+	ffmpeg        string
+	thumbnailSize int
+	previewHeight int
+	timeout       time.Duration
+	workers       int
+	spoolDir      string
+	jobs          chan EvidenceDocument
+	// record anchors a derivative; ledger.RecordEvidenceDerivative outside tests
+	record func(ctx context.Context, derivative EvidenceDerivative) error
+}
+
+var media *mediaPipeline
+
+// initMediaPipeline runs after initEvidenceUploads, whose spool directory it shares
+func initMediaPipeline() {
+	if !getEnvBool("EVIDENCE_MEDIA_PIPELINE", false) {
+		return
+	}
+	media = &mediaPipeline{
+		ffmpeg:        getEnv("FFMPEG_PATH", ""),
+		thumbnailSize: getEnvInt("EVIDENCE_THUMBNAIL_SIZE", 320),
+		previewHeight: getEnvInt("EVIDENCE_PREVIEW_HEIGHT", 480),
+		timeout:       getEnvDuration("EVIDENCE_MEDIA_TIMEOUT", 10*time.Minute),
+		workers:       getEnvInt("EVIDENCE_MEDIA_WORKERS", 2),
+		spoolDir:      uploads.spoolDir,
+		jobs:          make(chan EvidenceDocument, getEnvInt("EVIDENCE_MEDIA_QUEUE", 100)),
+		record:        ledger.RecordEvidenceDerivative,
+	}
+	if media.thumbnailSize < 16 || media.previewHeight < 16 || media.timeout <= 0 || media.workers < 1 || cap(media.jobs) < 1 {
+		panic(fmt.Errorf("EVIDENCE_THUMBNAIL_SIZE, EVIDENCE_PREVIEW_HEIGHT, EVIDENCE_MEDIA_TIMEOUT, EVIDENCE_MEDIA_WORKERS and EVIDENCE_MEDIA_QUEUE must be positive"))
+	}
+	if media.ffmpeg == "" {
+		media.ffmpeg, _ = exec.LookPath("ffmpeg")
+	}
+	tools := "ffmpeg not found, so only JPEG and PNG images are processed"
+	if media.ffmpeg != "" {
+		tools = "video and audio through " + media.ffmpeg
+	}
+	log.Printf("🎞️  Deriving evidence thumbnails, previews and sanitized copies with %d workers, %s", media.workers, tools)
+}
+
+// evidenceDerivativeKey is where a derived copy lives in the evidence store, apart from the originals
+func evidenceDerivativeKey(incidentID, evidenceID, kind string) string {
+	return fmt.Sprintf("evidence-derived/%s/%s/%s", incidentID, evidenceID, kind)
+}
+
+// queue hands an uploaded evidence file to the workers. When they are behind the file is skipped;
+// POST /evidence/:id/derivatives processes it later.
+func (m *mediaPipeline) queue(evidence EvidenceDocument) bool {
+	select {
+	case m.jobs <- evidence:
+		return true
+	default:
+		log.Printf("🎞️  Media pipeline queue full, skipped evidence %s", evidence.EvidenceID)
+		return false
+	}
+}
+
+// run processes queued evidence until ctx is done
+func (m *mediaPipeline) run(ctx context.Context) {
+	for i := 0; i < m.workers; i++ {
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case evidence := <-m.jobs:
+					jobCtx, cancel := context.WithTimeout(withTarget(ctx, defaultTarget), m.timeout)
+					if err := m.process(jobCtx, evidence); err != nil {
+						log.Printf("🎞️  Failed to process evidence %s: %v", evidence.EvidenceID, err)
+					}
+					cancel()
+				}
+			}
+		}()
+	}
+	<-ctx.Done()
+}
+
+// mediaStep derives one copy from the spooled original into out and describes how
+type mediaStep struct {
+	kind      string
+	mediaType string
+	derive    func(ctx context.Context, original *os.File, out *os.File) (transformation string, err error)
+}
+
+// steps returns what the pipeline derives from a media type
+func (m *mediaPipeline) steps(mediaType string) []mediaStep {
+	var steps []mediaStep
+	switch {
+	case mediaType == "image/jpeg" || mediaType == "image/png":
+		steps = append(steps,
+			mediaStep{derivativeThumbnail, "image/jpeg", m.imageThumbnail},
+			mediaStep{derivativeSanitized, mediaType, func(_ context.Context, original, out *os.File) (string, error) {
+				return stripImageMetadata(mediaType, original, out)
+			}})
+	case m.ffmpeg == "":
+	case mediaType == "image/heic":
+		steps = append(steps, mediaStep{derivativeThumbnail, "image/jpeg", m.ffmpegThumbnail})
+	case strings.HasPrefix(mediaType, "video/"):
+		steps = append(steps,
+			mediaStep{derivativeThumbnail, "image/jpeg", m.ffmpegThumbnail},
+			mediaStep{derivativePreview, "video/mp4", m.ffmpegPreview},
+			mediaStep{derivativeSanitized, mediaType, m.ffmpegRemux(ffmpegFormats[mediaType])})
+	case ffmpegFormats[mediaType] != "":
+		steps = append(steps, mediaStep{derivativeSanitized, mediaType, m.ffmpegRemux(ffmpegFormats[mediaType])})
+	}
+	return steps
+}
+
+// process derives every copy the evidence's media type supports. The original is read once into a
+// spool file, and only processed when it still matches its anchored hash.
+func (m *mediaPipeline) process(ctx context.Context, evidence EvidenceDocument) error {
+	steps := m.steps(evidence.MediaType)
+	if len(steps) == 0 {
+		return nil
+	}
+	original, err := m.spoolOriginal(ctx, evidence)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(original.Name())
+	defer original.Close()
+
+	var errs []error
+	for _, step := range steps {
+		if err := m.derive(ctx, evidence, original, step); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", step.kind, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (m *mediaPipeline) spoolOriginal(ctx context.Context, evidence EvidenceDocument) (*os.File, error) {
+	key := evidenceObjectKey(evidence.IncidentID, evidence.EvidenceID)
+	body, err := evidenceStore.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	var plaintext io.Reader = body
+	if evidence.EncryptionKeyRef != "" {
+		if evidenceKeys == nil {
+			return nil, errors.New("evidence is encrypted but EVIDENCE_ENCRYPTION is not configured")
+		}
+		aead, err := evidenceKeys.open(ctx, evidence.EncryptionKeyRef)
+		if err != nil {
+			return nil, err
+		}
+		plaintext = newDecryptingReader(body, aead, key)
+	}
+
+	spool, err := os.CreateTemp(m.spoolDir, "evidence-media-*")
+	if err != nil {
+		return nil, err
+	}
+	hasher := sha256.New()
+	_, err = io.Copy(io.MultiWriter(spool, hasher), plaintext)
+	if err == nil && hex.EncodeToString(hasher.Sum(nil)) != evidence.EvidenceHash {
+		err = fmt.Errorf("stored file does not match the anchored hash %s", evidence.EvidenceHash)
+	}
+	if err == nil {
+		_, err = spool.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		spool.Close()
+		os.Remove(spool.Name())
+		return nil, err
+	}
+	return spool, nil
+}
+
+// derive runs one step, stores the copy, encrypted like its original, and anchors it
+func (m *mediaPipeline) derive(ctx context.Context, evidence EvidenceDocument, original *os.File, step mediaStep) error {
+	if _, err := original.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	out, err := os.CreateTemp(m.spoolDir, "evidence-derived-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+	defer out.Close()
+	transformation, err := step.derive(ctx, original, out)
+	if err != nil {
+		return err
+	}
+	if _, err := out.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	key := evidenceDerivativeKey(evidence.IncidentID, evidence.EvidenceID, step.kind)
+	hasher := sha256.New()
+	counter := &countingReader{reader: io.TeeReader(out, hasher)}
+	var body io.Reader = counter
+	storedType, keyRef := step.mediaType, ""
+	if evidence.EncryptionKeyRef != "" {
+		if evidenceKeys == nil {
+			return errors.New("evidence is encrypted but EVIDENCE_ENCRYPTION is not configured")
+		}
+		ref, aead, err := evidenceKeys.current(ctx, evidence.IncidentID)
+		if err != nil {
+			return err
+		}
+		if body, err = newEncryptingReader(counter, aead, key); err != nil {
+			return err
+		}
+		keyRef, storedType = ref, "application/octet-stream"
+	}
+	if err := evidenceStore.Put(ctx, key, body, -1, storedType); err != nil {
+		return err
+	}
+	if counter.count == 0 {
+		evidenceStore.Delete(ctx, key)
+		return errors.New("produced an empty file")
+	}
+
+	return m.record(ctx, EvidenceDerivative{
+		EvidenceID:       evidence.EvidenceID,
+		IncidentID:       evidence.IncidentID,
+		Kind:             step.kind,
+		ParentHash:       evidence.EvidenceHash,
+		DerivativeHash:   hex.EncodeToString(hasher.Sum(nil)),
+		MediaType:        step.mediaType,
+		Transformation:   transformation,
+		EncryptionKeyRef: keyRef,
+	})
+}
+
+// imageThumbnail decodes a JPEG or PNG image and encodes it as a JPEG fitting the thumbnail size
+func (m *mediaPipeline) imageThumbnail(_ context.Context, original, out *os.File) (string, error) {
+	config, _, err := image.DecodeConfig(original)
+	if err != nil {
+		return "", err
+	}
+	if config.Width*config.Height > maxThumbnailPixels {
+		return "", fmt.Errorf("image of %dx%d is too large to decode", config.Width, config.Height)
+	}
+	if _, err := original.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	img, _, err := image.Decode(original)
+	if err != nil {
+		return "", err
+	}
+	if err := jpeg.Encode(out, fitThumbnail(img, m.thumbnailSize), &jpeg.Options{Quality: thumbnailQuality}); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("thumbnail: box filter to fit %dx%d over white, JPEG quality %d", m.thumbnailSize, m.thumbnailSize, thumbnailQuality), nil
+}
+
+// fitThumbnail box-filters an image down to fit within size×size, keeping its aspect ratio, and
+// flattens it onto white. Large boxes are sampled on a 4×4 grid.
+func fitThumbnail(img image.Image, size int) *image.RGBA {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	tw, th := w, h
+	if w > size || h > size {
+		if w >= h {
+			tw, th = size, max(1, h*size/w)
+		} else {
+			tw, th = max(1, w*size/h), size
+		}
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, tw, th))
+	for y := 0; y < th; y++ {
+		y0, y1 := b.Min.Y+y*h/th, b.Min.Y+max((y+1)*h/th, y*h/th+1)
+		stepY := max(1, (y1-y0)/4)
+		for x := 0; x < tw; x++ {
+			x0, x1 := b.Min.X+x*w/tw, b.Min.X+max((x+1)*w/tw, x*w/tw+1)
+			stepX := max(1, (x1-x0)/4)
+			var r, g, bl, n uint64
+			for sy := y0; sy < y1; sy += stepY {
+				for sx := x0; sx < x1; sx += stepX {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					white := uint64(0xffff - ca)
+					r, g, bl, n = r+uint64(cr)+white, g+uint64(cg)+white, bl+uint64(cb)+white, n+1
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{uint8(r / n >> 8), uint8(g / n >> 8), uint8(bl / n >> 8), 0xff})
+		}
+	}
+	return dst
+}
+
+// stripImageMetadata copies a JPEG or PNG image without the segments or chunks that carry metadata,
+// such as EXIF with its GPS position, XMP, IPTC and comments. Image data is copied byte for byte.
+func stripImageMetadata(mediaType string, original io.Reader, out io.Writer) (string, error) {
+	data, err := io.ReadAll(original)
+	if err != nil {
+		return "", err
+	}
+	var stripped []byte
+	if mediaType == "image/png" {
+		stripped, err = stripPNGMetadata(data)
+	} else {
+		stripped, err = stripJPEGMetadata(data)
+	}
+	if err != nil {
+		return "", err
+	}
+	if _, err := out.Write(stripped); err != nil {
+		return "", err
+	}
+	if mediaType == "image/png" {
+		return "sanitized: removed ancillary PNG chunks other than color, transparency and density", nil
+	}
+	return "sanitized: removed JPEG APP1, APP3-APP13, APP15 and COM segments and non-ICC APP2", nil
+}
+
+// stripJPEGMetadata keeps APP0 (JFIF), APP2 ICC profiles and APP14 (Adobe color transform) ahead of
+// the scan, and everything from the start of scan on
+func stripJPEGMetadata(data []byte) ([]byte, error) {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil, errors.New("not a JPEG file")
+	}
+	out := []byte{0xff, 0xd8}
+	for i := 2; ; {
+		if i+4 > len(data) || data[i] != 0xff {
+			return nil, errors.New("malformed JPEG segment")
+		}
+		marker := data[i+1]
+		if marker == 0xff {
+			i++
+			continue
+		}
+		if marker == 0xda {
+			return append(out, data[i:]...), nil
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		end := i + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, errors.New("truncated JPEG segment")
+		}
+		payload := data[i+4 : end]
+		keep := true
+		switch {
+		case marker == 0xe2:
+			keep = bytes.HasPrefix(payload, []byte("ICC_PROFILE\x00"))
+		case marker >= 0xe1 && marker <= 0xef && marker != 0xee, marker == 0xfe:
+			keep = false
+		}
+		if keep {
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+}
+
+// pngKeptChunks are the ancillary PNG chunks needed to display an image faithfully; critical chunks
+// are always kept
+var pngKeptChunks = []string{"tRNS", "gAMA", "cHRM", "sRGB", "iCCP", "sBIT", "pHYs", "bKGD", "acTL", "fcTL", "fdAT"}
+
+func stripPNGMetadata(data []byte) ([]byte, error) {
+	const signature = "\x89PNG\r\n\x1a\n"
+	if !bytes.HasPrefix(data, []byte(signature)) {
+		return nil, errors.New("not a PNG file")
+	}
+	out := []byte(signature)
+	for i := len(signature); i < len(data); {
+		if i+12 > len(data) {
+			return nil, errors.New("truncated PNG chunk")
+		}
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		end := i + 12 + length
+		if end > len(data) {
+			return nil, errors.New("truncated PNG chunk")
+		}
+		kind := string(data[i+4 : i+8])
+		// Critical chunk types start with an upper-case letter
+		if kind[0] >= 'A' && kind[0] <= 'Z' || slices.Contains(pngKeptChunks, kind) {
+			out = append(out, data[i:end]...)
+		}
+		i = end
+		if kind == "IEND" {
+			return out, nil
+		}
+	}
+	return nil, errors.New("PNG file has no IEND chunk")
+}
+
+// runFFmpeg runs ffmpeg on the original, writing into out's path
+func (m *mediaPipeline) runFFmpeg(ctx context.Context, original, out *os.File, args ...string) error {
+	args = append(append([]string{"-hide_banner", "-loglevel", "error", "-nostdin", "-y", "-i", original.Name()}, args...), out.Name())
+	output, err := exec.CommandContext(ctx, m.ffmpeg, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("ffmpeg: %w: %s", err, bytes.TrimSpace(output))
+	}
+	return nil
+}
+
+// ffmpegThumbnail picks a representative video frame, or the HEIC image, and scales it down
+func (m *mediaPipeline) ffmpegThumbnail(ctx context.Context, original, out *os.File) (string, error) {
+	filter := fmt.Sprintf("thumbnail,scale=%d:%d:force_original_aspect_ratio=decrease", m.thumbnailSize, m.thumbnailSize)
+	if err := m.runFFmpeg(ctx, original, out, "-vf", filter, "-frames:v", "1", "-map_metadata", "-1", "-f", "image2", "-c:v", "mjpeg", "-q:v", "4"); err != nil {
+		return "", err
+	}
+	return "thumbnail: ffmpeg -vf " + filter + " -frames:v 1 -c:v mjpeg -q:v 4", nil
+}
+
+// ffmpegPreview transcodes a video to H.264 and AAC at the preview height, for playing in the
+// dashboard without downloading the original
+func (m *mediaPipeline) ffmpegPreview(ctx context.Context, original, out *os.File) (string, error) {
+	args := []string{"-map", "0:v:0", "-map", "0:a:0?", "-vf", fmt.Sprintf("scale=-2:'min(%d,ih)'", m.previewHeight),
+		"-c:v", "libx264", "-preset", "veryfast", "-crf", "28", "-pix_fmt", "yuv420p", "-c:a", "aac", "-b:a", "96k",
+		"-map_metadata", "-1", "-map_chapters", "-1", "-movflags", "+faststart", "-f", "mp4"}
+	if err := m.runFFmpeg(ctx, original, out, args...); err != nil {
+		return "", err
+	}
+	return "preview: ffmpeg " + strings.Join(args, " "), nil
+}
+
+// ffmpegRemux copies the audio and video streams into a new container of the same format without
+// its metadata, which holds the recording device and position
+func (m *mediaPipeline) ffmpegRemux(format string) func(context.Context, *os.File, *os.File) (string, error) {
+	return func(ctx context.Context, original, out *os.File) (string, error) {
+		args := []string{"-map", "0:v?", "-map", "0:a?", "-c", "copy", "-map_metadata", "-1", "-map_chapters", "-1", "-fflags", "+bitexact", "-f", format}
+		if err := m.runFFmpeg(ctx, original, out, args...); err != nil {
+			return "", err
+		}
+		return "sanitized: ffmpeg " + strings.Join(args, " "), nil
+	}
+}
+
+// RecordEvidenceDerivative anchors a copy derived from an evidence file
+func (ledgerService) RecordEvidenceDerivative(ctx context.Context, derivative EvidenceDerivative) error {
+	_, err := submitTransaction(ctx, "RecordEvidenceDerivative", derivative.EvidenceID, derivative.Kind, derivative.ParentHash,
+		derivative.DerivativeHash, derivative.MediaType, derivative.Transformation, derivative.EncryptionKeyRef, mediaPipelineActor)
+	return err
+}
+
+// ListEvidenceDerivatives returns the copies derived from an evidence file, marking those made from
+// an earlier version of it as stale
+func (ledgerService) ListEvidenceDerivatives(ctx context.Context, evidence EvidenceDocument) ([]EvidenceDerivative, error) {
+	result, err := evaluateTransaction(ctx, "GetEvidenceDerivatives", evidence.EvidenceID)
+	if err != nil {
+		return nil, err
+	}
+	derivatives, err := decodeDocument[[]EvidenceDerivative](result, "evidence derivative")
+	if err != nil {
+		return nil, err
+	}
+	for i := range derivatives {
+		derivatives[i].Stale = derivatives[i].ParentHash != evidence.EvidenceHash
+	}
+	return filterOwned(tenantFromContext(ctx), derivatives, func(d EvidenceDerivative) string { return d.OwnerOrg }), nil
+}
+
+func listEvidenceDerivatives(c *gin.Context) {
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	evidence, err := ledger.GetEvidence(ctx, id)
+	if err != nil {
+		respondServiceError(c, "Failed to read evidence", err)
+		return
+	}
+	derivatives, err := ledger.ListEvidenceDerivatives(ctx, evidence)
+	if err != nil {
+		respondServiceError(c, "Failed to list evidence derivatives", err)
+		return
+	}
+	respondData(c, http.StatusOK, derivatives)
+}
+
+// EvidenceMediaQueued acknowledges evidence queued for the media pipeline
+type EvidenceMediaQueued struct {
+	EvidenceID string `json:"evidenceID"`
+	Queued     bool   `json:"queued"`
+}
+
+// processEvidenceMedia queues an uploaded evidence file for the media pipeline again, for files
+// skipped while it was behind or made stale by an update
+func processEvidenceMedia(c *gin.Context) {
+	if media == nil {
+		respondError(c, http.StatusServiceUnavailable, errCodeMediaPipelineDisabled, "The evidence media pipeline is not enabled")
+		return
+	}
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	setAuditTarget(c, id)
+	evidence, err := ledger.GetEvidence(c.Request.Context(), id)
+	if err != nil {
+		respondServiceError(c, "Failed to read evidence", err)
+		return
+	}
+	if len(media.steps(evidence.MediaType)) == 0 {
+		respondError(c, http.StatusUnprocessableEntity, errCodeValidation, fmt.Sprintf("Nothing is derived from %s evidence", evidence.MediaType))
+		return
+	}
+	if !media.queue(evidence) {
+		c.Header("Retry-After", "30")
+		respondError(c, http.StatusServiceUnavailable, errCodeUnavailable, "The media pipeline is busy")
+		return
+	}
+	respondData(c, http.StatusAccepted, EvidenceMediaQueued{EvidenceID: id, Queued: true})
+}
+
+// downloadEvidenceDerivative serves a derived copy once it matches its anchored hash. Encrypted
+// copies need the same permission as their original.
+func downloadEvidenceDerivative(c *gin.Context) {
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	kind := c.Param("kind")
+	var v fieldValidator
+	v.oneOf("kind", kind, derivativeKinds)
+	if len(v.errors) > 0 {
+		respondValidationErrors(c, v.errors)
+		return
+	}
+	ctx := c.Request.Context()
+	evidence, err := ledger.GetEvidence(ctx, id)
+	if err != nil {
+		respondServiceError(c, "Failed to read evidence", err)
+		return
+	}
+	derivatives, err := ledger.ListEvidenceDerivatives(ctx, evidence)
+	if err != nil {
+		respondServiceError(c, "Failed to list evidence derivatives", err)
+		return
+	}
+	i := slices.IndexFunc(derivatives, func(d EvidenceDerivative) bool { return d.Kind == kind })
+	if i < 0 {
+		respondError(c, http.StatusNotFound, errCodeNotFound, fmt.Sprintf("No %s derived from evidence %s", kind, id))
+		return
+	}
+	derivative := derivatives[i]
+
+	key := evidenceDerivativeKey(evidence.IncidentID, id, kind)
+	plaintext := storedAsIs
+	if derivative.EncryptionKeyRef != "" {
+		if plaintext, ok = evidenceObjectPlaintext(c, evidence.IncidentID, derivative.EncryptionKeyRef, key); !ok {
+			return
+		}
+	}
+	storedHash, err := hashStoredPlaintext(ctx, key, plaintext)
+	if errors.Is(err, errObjectNotFound) {
+		respondError(c, http.StatusNotFound, errCodeNotFound, fmt.Sprintf("No stored %s for evidence %s", kind, id))
+		return
+	}
+	if err != nil && !errors.Is(err, errEvidenceUndecryptable) {
+		logWithContext(ctx, "Failed to read evidence derivative %s: %v", key, err)
+		respondError(c, http.StatusServiceUnavailable, errCodeUnavailable, "Failed to read evidence derivative from storage")
+		return
+	}
+	if err != nil || storedHash != derivative.DerivativeHash {
+		logWithContext(ctx, "⚠️  Evidence %s %s does not match its anchored hash %s", id, kind, derivative.DerivativeHash)
+		respondError(c, http.StatusConflict, errCodeEvidenceTampered, "Stored copy does not match the hash anchored on the ledger")
+		return
+	}
+
+	body, err := evidenceStore.Get(ctx, key)
+	if err != nil {
+		respondError(c, http.StatusServiceUnavailable, errCodeUnavailable, "Failed to read evidence derivative from storage")
+		return
+	}
+	defer body.Close()
+	c.DataFromReader(http.StatusOK, -1, derivative.MediaType, plaintext(body), map[string]string{
+		"Content-Disposition": fmt.Sprintf(`inline; filename="%s-%s"`, id, kind),
+		"X-Evidence-Hash":     derivative.DerivativeHash,
+		"X-Evidence-Parent":   derivative.ParentHash,
+	})
+}
@@ -0,0 +1,133 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"strings"
+	"testing"
+)
+
+func testImage(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetRGBA(x, y, color.RGBA{uint8(x), uint8(y), 0x80, 0xff})
+		}
+	}
+	return img
+}
+
+// photoWithEXIF is a JPEG carrying an EXIF segment with a GPS position and a comment, as a phone
+// camera writes them
+func photoWithEXIF(t *testing.T) []byte {
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, testImage(640, 480), nil); err != nil {
+		t.Fatal(err)
+	}
+	segment := func(marker byte, payload string) []byte {
+		return append([]byte{0xff, marker, byte((len(payload) + 2) >> 8), byte(len(payload) + 2)}, payload...)
+	}
+	data := append([]byte{0xff, 0xd8}, segment(0xe1, "Exif\x00\x00GPSLatitude=25.5788;GPSLongitude=91.8933")...)
+	data = append(data, segment(0xfe, "Shot on tourist phone")...)
+	return append(data, encoded.Bytes()[2:]...)
+}
+
+func TestStripImageMetadata(t *testing.T) {
+	photo := photoWithEXIF(t)
+	var out bytes.Buffer
+	if _, err := stripImageMetadata("image/jpeg", bytes.NewReader(photo), &out); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(out.Bytes(), []byte("GPSLatitude")) || bytes.Contains(out.Bytes(), []byte("tourist phone")) {
+		t.Error("expected the EXIF and comment segments removed")
+	}
+	if _, err := jpeg.Decode(&out); err != nil {
+		t.Errorf("expected the sanitized copy to decode, got %v", err)
+	}
+
+	var encoded bytes.Buffer
+	png.Encode(&encoded, testImage(32, 32))
+	text := []byte("tEXtLocation\x00Police Bazar, Shillong")
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(text)-4))
+	chunk = binary.BigEndian.AppendUint32(append(chunk, text...), crc32.ChecksumIEEE(text))
+	iend := bytes.LastIndex(encoded.Bytes(), []byte("IEND")) - 4
+	withText := append(append(append([]byte(nil), encoded.Bytes()[:iend]...), chunk...), encoded.Bytes()[iend:]...)
+	out.Reset()
+	if _, err := stripImageMetadata("image/png", bytes.NewReader(withText), &out); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), encoded.Bytes()) {
+		t.Error("expected only the text chunk removed")
+	}
+
+	if _, err := stripImageMetadata("image/jpeg", strings.NewReader("\xff\xd8\xff\xe1\xff\xff"), io.Discard); err == nil {
+		t.Error("expected a truncated JPEG refused")
+	}
+}
+
+func TestMediaPipeline(t *testing.T) {
+	store, err := newLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	previousStore := evidenceStore
+	evidenceStore = store
+	defer func() { evidenceStore = previousStore }()
+	ctx := context.Background()
+
+	photo := photoWithEXIF(t)
+	sum := sha256.Sum256(photo)
+	evidence := EvidenceDocument{EvidenceID: "EV-PHOTO-1", EvidenceHash: hex.EncodeToString(sum[:]), IncidentID: "INC-1", MediaType: "image/jpeg"}
+	key := evidenceObjectKey(evidence.IncidentID, evidence.EvidenceID)
+	if err := store.Put(ctx, key, bytes.NewReader(photo), int64(len(photo)), evidence.MediaType); err != nil {
+		t.Fatal(err)
+	}
+
+	var recorded []EvidenceDerivative
+	m := &mediaPipeline{thumbnailSize: 160, spoolDir: t.TempDir(), record: func(_ context.Context, d EvidenceDerivative) error {
+		recorded = append(recorded, d)
+		return nil
+	}}
+	if steps := m.steps("video/mp4"); len(steps) != 0 {
+		t.Errorf("expected video skipped without ffmpeg, got %d steps", len(steps))
+	}
+	if err := m.process(ctx, evidence); err != nil {
+		t.Fatal(err)
+	}
+	if len(recorded) != 2 || recorded[0].Kind != derivativeThumbnail || recorded[1].Kind != derivativeSanitized {
+		t.Fatalf("expected a thumbnail and a sanitized copy, got %+v", recorded)
+	}
+	for _, d := range recorded {
+		if d.ParentHash != evidence.EvidenceHash || d.DerivativeHash == evidence.EvidenceHash || d.Transformation == "" {
+			t.Errorf("unexpected derivative %+v", d)
+		}
+		if stored, err := hashStoredObject(ctx, evidenceDerivativeKey(evidence.IncidentID, evidence.EvidenceID, d.Kind)); err != nil || stored != d.DerivativeHash {
+			t.Errorf("expected the stored %s to match its hash, got %s %v", d.Kind, stored, err)
+		}
+	}
+	body, _ := store.Get(ctx, evidenceDerivativeKey(evidence.IncidentID, evidence.EvidenceID, derivativeThumbnail))
+	thumbnail, err := jpeg.DecodeConfig(body)
+	body.Close()
+	if err != nil || thumbnail.Width != 160 || thumbnail.Height != 120 {
+		t.Errorf("expected a 160x120 thumbnail, got %+v %v", thumbnail, err)
+	}
+	if original, _ := hashStoredObject(ctx, key); original != evidence.EvidenceHash {
+		t.Error("expected the original left untouched")
+	}
+
+	// An original that no longer matches its anchored hash is not processed
+	recorded = nil
+	store.Put(ctx, key, bytes.NewReader(append(photo, 0)), -1, evidence.MediaType)
+	if err := m.process(ctx, evidence); err == nil || len(recorded) != 0 {
+		t.Errorf("expected a tampered original refused, got %v and %d derivatives", err, len(recorded))
+	}
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// scanVerdict is a malware scanner's result for one file
+type scanVerdict struct {
+	Clean bool `json:"clean"`
+	// Signature names what was found in an infected file
+	Signature string `json:"signature,omitempty"`
+}
+
+// malwareScanner inspects an evidence file before it is stored, hashed and anchored
+type malwareScanner interface {
+	name() string
+	scan(ctx context.Context, file io.Reader) (scanVerdict, error)
+}
+
+// clamdScanner streams files to a ClamAV daemon with the INSTREAM command
+type clamdScanner struct {
+	addr    string
+	timeout time.Duration
+}
+
+const clamdChunkSize = 64 * 1024
+
+func (s *clamdScanner) name() string { return "clamd" }
+
+func (s *clamdScanner) scan(ctx context.Context, file io.Reader) (scanVerdict, error) {
+	dialer := net.Dialer{Timeout: s.timeout}
+	network := "tcp"
+	if strings.HasPrefix(s.addr, "/") {
+		network = "unix"
+	}
+	conn, err := dialer.DialContext(ctx, network, s.addr)
+	if err != nil {
+		return scanVerdict{}, err
+	}
+	defer conn.Close()
+	deadline := time.Now().Add(s.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return scanVerdict{}, err
+	}
+	chunk := make([]byte, clamdChunkSize)
+	size := make([]byte, 4)
+	for {
+		n, readErr := file.Read(chunk)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size, uint32(n))
+			if _, err := conn.Write(append(size, chunk[:n]...)); err != nil {
+				return scanVerdict{}, err
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return scanVerdict{}, readErr
+		}
+	}
+	if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return scanVerdict{}, err
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && reply == "" {
+		return scanVerdict{}, err
+	}
+	return parseClamdReply(strings.TrimRight(reply, "\x00\n"))
+}
+
+// parseClamdReply reads "stream: OK" or "stream: <signature> FOUND"
+func parseClamdReply(reply string) (scanVerdict, error) {
+	result := strings.TrimSpace(strings.TrimPrefix(reply, "stream:"))
+	switch {
+	case result == "OK":
+		return scanVerdict{Clean: true}, nil
+	case strings.HasSuffix(result, " FOUND"):
+		return scanVerdict{Signature: strings.TrimSuffix(result, " FOUND")}, nil
+	}
+	return scanVerdict{}, fmt.Errorf("clamd: %s", result)
+}
+
+// httpScanner posts files to a scanning service that answers with a scanVerdict
+type httpScanner struct {
+	endpoint   string
+	token      string
+	httpClient *http.Client
+}
+
+func (s *httpScanner) name() string { return "http" }
+
+func (s *httpScanner) scan(ctx context.Context, file io.Reader) (scanVerdict, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, file)
+	if err != nil {
+		return scanVerdict{}, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return scanVerdict{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return scanVerdict{}, fmt.Errorf("scanner responded with %d: %s", resp.StatusCode, bytes.TrimSpace(body))
+	}
+	var verdict scanVerdict
+	if err := json.NewDecoder(resp.Body).Decode(&verdict); err != nil {
+		return scanVerdict{}, fmt.Errorf("invalid scanner response: %w", err)
+	}
+	return verdict, nil
+}
@@ -0,0 +1,440 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	uploadFileField   = "file"
+	maxFormFieldBytes = 1024
+	// uploadFormOverhead allows for the form fields and multipart boundaries around the file
+	uploadFormOverhead = 64 * 1024
+	// sniffLength is how much of a file is inspected to detect its media type
+	sniffLength = 512
+
+	errCodePayloadTooLarge    = "PAYLOAD_TOO_LARGE"
+	errCodeEvidenceInfected   = "EVIDENCE_INFECTED"
+	errCodeScannerUnavailable = "SCANNER_UNAVAILABLE"
+)
+
+var errUploadTooLarge = errors.New("evidence file exceeds the upload limit")
+
+// uploadPolicy decides which evidence files the upload endpoint accepts
+type uploadPolicy struct {
+	maxBytes int64
+	// mediaTypes narrows allowedMediaTypes for uploads
+	mediaTypes []string
+	scanner    malwareScanner
+	// scanFailOpen accepts files unscanned while the scanner is unreachable, instead of refusing them
+	scanFailOpen bool
+	spoolDir     string
+}
+
+var uploads = uploadPolicy{maxBytes: 100 << 20, mediaTypes: allowedMediaTypes}
+
+// initEvidenceUploads reads the upload limits and selects the malware scanner from EVIDENCE_SCANNER
+func initEvidenceUploads() {
+	policy := uploadPolicy{
+		maxBytes:     int64(getEnvInt("EVIDENCE_MAX_UPLOAD_BYTES", 100<<20)),
+		mediaTypes:   allowedMediaTypes,
+		scanFailOpen: getEnvBool("EVIDENCE_SCAN_FAIL_OPEN", false),
+		spoolDir:     getEnv("EVIDENCE_SCAN_SPOOL_DIR", os.TempDir()),
+	}
+	if policy.maxBytes <= 0 {
+		panic(fmt.Errorf("EVIDENCE_MAX_UPLOAD_BYTES must be positive"))
+	}
+	if list := getEnv("EVIDENCE_ALLOWED_MEDIA_TYPES", ""); list != "" {
+		policy.mediaTypes = nil
+		for _, mediaType := range strings.Split(list, ",") {
+			mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+			if !slices.Contains(allowedMediaTypes, mediaType) {
+				panic(fmt.Errorf("EVIDENCE_ALLOWED_MEDIA_TYPES: %q is not accepted by the chaincode", mediaType))
+			}
+			policy.mediaTypes = append(policy.mediaTypes, mediaType)
+		}
+	}
+
+	timeout := getEnvDuration("EVIDENCE_SCAN_TIMEOUT", time.Minute)
+	switch kind := getEnv("EVIDENCE_SCANNER", ""); kind {
+	case "":
+	case "clamd":
+		policy.scanner = &clamdScanner{addr: getEnv("CLAMD_ADDR", "localhost:3310"), timeout: timeout}
+	case "http":
+		endpoint := getEnv("EVIDENCE_SCAN_URL", "")
+		if endpoint == "" {
+			panic(fmt.Errorf("EVIDENCE_SCAN_URL is required with EVIDENCE_SCANNER=http"))
+		}
+		policy.scanner = &httpScanner{endpoint: endpoint, token: getEnv("EVIDENCE_SCAN_TOKEN", ""), httpClient: &http.Client{Timeout: timeout}}
+	default:
+		panic(fmt.Errorf("unknown EVIDENCE_SCANNER %q, expected clamd or http", kind))
+	}
+
+	uploads = policy
+	scanning := "without malware scanning"
+	if policy.scanner != nil {
+		scanning = "scanned with " + policy.scanner.name()
+	}
+	log.Printf("🛡️  Evidence uploads up to %d bytes, %d media types, %s", policy.maxBytes, len(policy.mediaTypes), scanning)
+}
+
+// UploadEvidenceRequest holds the form fields sent alongside an evidence file
+type UploadEvidenceRequest struct {
+	EvidenceID string `json:"evidenceID" binding:"required"`
+	IncidentID string `json:"incidentID" binding:"required"`
+	UploadedBy string `json:"uploadedBy" binding:"required"`
+}
+
+func (r UploadEvidenceRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.identifier("evidenceID", r.EvidenceID)
+	v.identifier("incidentID", r.IncidentID)
+	v.identifier("uploadedBy", r.UploadedBy)
+	return v.errors
+}
+
+// evidenceObjectKey is where an evidence binary lives in the evidence store
+func evidenceObjectKey(incidentID, evidenceID string) string {
+	return fmt.Sprintf("evidence/%s/%s", incidentID, evidenceID)
+}
+
+// uploadEvidence streams a multipart file into the evidence store while hashing it, then anchors
+// the server-computed SHA-256 on the ledger. Form fields must precede the file part. The file's
+// content must match its declared media type, and with a scanner configured it is scanned first.
+func uploadEvidence(c *gin.Context) {
+	limit := uploads.maxBytes + uploadFormOverhead
+	if c.Request.ContentLength > limit {
+		respondUploadTooLarge(c)
+		return
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		respondValidationErrors(c, ValidationErrors{{Field: "body", Message: "must be multipart/form-data"}})
+		return
+	}
+
+	var req UploadEvidenceRequest
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			respondValidationErrors(c, ValidationErrors{{Field: uploadFileField, Message: "is required"}})
+			return
+		}
+		if err != nil {
+			if isUploadTooLarge(err) {
+				respondUploadTooLarge(c)
+				return
+			}
+			respondValidationErrors(c, ValidationErrors{{Field: "body", Message: err.Error()}})
+			return
+		}
+
+		if part.FormName() != uploadFileField {
+			value, err := io.ReadAll(io.LimitReader(part, maxFormFieldBytes))
+			part.Close()
+			if isUploadTooLarge(err) {
+				respondUploadTooLarge(c)
+				return
+			}
+			if err != nil {
+				respondValidationErrors(c, ValidationErrors{{Field: part.FormName(), Message: err.Error()}})
+				return
+			}
+			switch part.FormName() {
+			case "evidenceID":
+				req.EvidenceID = string(value)
+			case "incidentID":
+				req.IncidentID = string(value)
+			case "uploadedBy":
+				req.UploadedBy = string(value)
+			}
+			continue
+		}
+
+		setAuditTarget(c, req.EvidenceID)
+		if errs := req.Validate(); len(errs) > 0 {
+			part.Close()
+			respondValidationErrors(c, errs)
+			return
+		}
+
+		defer part.Close()
+		mediaType := partMediaType(part.Header.Get("Content-Type"))
+		var v fieldValidator
+		v.oneOf("mediaType", mediaType, uploads.mediaTypes)
+		if len(v.errors) > 0 {
+			respondValidationErrors(c, v.errors)
+			return
+		}
+
+		// Trust the content, not the declared type
+		file := bufio.NewReaderSize(&uploadLimitReader{reader: part, limit: uploads.maxBytes}, sniffLength)
+		head, err := file.Peek(sniffLength)
+		if err != nil && !errors.Is(err, io.EOF) {
+			if isUploadTooLarge(err) {
+				respondUploadTooLarge(c)
+			} else {
+				respondValidationErrors(c, ValidationErrors{{Field: uploadFileField, Message: err.Error()}})
+			}
+			return
+		}
+		if len(head) == 0 {
+			respondValidationErrors(c, ValidationErrors{{Field: uploadFileField, Message: "must not be empty"}})
+			return
+		}
+		if !slices.Contains(sniffMediaTypes(head), mediaType) {
+			v.add(uploadFileField, "content does not match the declared media type %s", mediaType)
+			respondValidationErrors(c, v.errors)
+			return
+		}
+
+		if uploads.scanner == nil {
+			storeAndAnchorEvidence(c, req, mediaType, file)
+			return
+		}
+		spool := scanEvidence(c, file)
+		if spool == nil {
+			return
+		}
+		defer os.Remove(spool.Name())
+		defer spool.Close()
+		storeAndAnchorEvidence(c, req, mediaType, spool)
+		return
+	}
+}
+
+// scanEvidence spools the file to disk and has it scanned, so nothing is stored, hashed or anchored
+// before the scanner has passed it. It returns nil, having responded, when the upload is refused.
+func scanEvidence(c *gin.Context, file io.Reader) *os.File {
+	ctx := c.Request.Context()
+	spool, err := os.CreateTemp(uploads.spoolDir, "evidence-scan-*")
+	if err != nil {
+		logWithContext(ctx, "Failed to spool evidence for scanning: %v", err)
+		respondError(c, http.StatusInternalServerError, errCodeInternal, "Failed to scan evidence file")
+		return nil
+	}
+	discard := func() {
+		spool.Close()
+		os.Remove(spool.Name())
+	}
+	if _, err := io.Copy(spool, file); err != nil {
+		discard()
+		if isUploadTooLarge(err) {
+			respondUploadTooLarge(c)
+		} else {
+			respondValidationErrors(c, ValidationErrors{{Field: uploadFileField, Message: err.Error()}})
+		}
+		return nil
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		discard()
+		respondError(c, http.StatusInternalServerError, errCodeInternal, "Failed to scan evidence file")
+		return nil
+	}
+
+	verdict, err := uploads.scanner.scan(ctx, spool)
+	switch {
+	case err != nil && !uploads.scanFailOpen:
+		discard()
+		logWithContext(ctx, "Malware scanner %s failed: %v", uploads.scanner.name(), err)
+		c.Header("Retry-After", "30")
+		respondError(c, http.StatusServiceUnavailable, errCodeScannerUnavailable, "Evidence could not be scanned for malware")
+		return nil
+	case err != nil:
+		logWithContext(ctx, "Malware scanner %s failed, accepting evidence unscanned: %v", uploads.scanner.name(), err)
+	case !verdict.Clean:
+		discard()
+		logWithContext(ctx, "Rejected evidence upload infected with %s", verdict.Signature)
+		respondError(c, http.StatusUnprocessableEntity, errCodeEvidenceInfected, "Evidence file failed the malware scan")
+		return nil
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		discard()
+		respondError(c, http.StatusInternalServerError, errCodeInternal, "Failed to scan evidence file")
+		return nil
+	}
+	return spool
+}
+
+// sniffMediaTypes returns the evidence media types a file's first bytes are consistent with. ISO
+// base media files are told apart by their ftyp brand, which http.DetectContentType ignores; generic
+// brands may hold either MP4 video or audio.
+func sniffMediaTypes(head []byte) []string {
+	if len(head) >= 12 && string(head[4:8]) == "ftyp" {
+		switch string(head[8:12]) {
+		case "qt  ":
+			return []string{"video/quicktime"}
+		case "M4A ", "M4B ", "M4P ":
+			return []string{"audio/mp4"}
+		case "heic", "heix", "hevc", "hevx", "heim", "heis", "mif1", "msf1":
+			return []string{"image/heic"}
+		}
+		return []string{"video/mp4", "audio/mp4"}
+	}
+	detected := partMediaType(http.DetectContentType(head))
+	if detected == "audio/wave" {
+		detected = "audio/wav"
+	}
+	return []string{detected}
+}
+
+func respondUploadTooLarge(c *gin.Context) {
+	respondError(c, http.StatusRequestEntityTooLarge, errCodePayloadTooLarge, fmt.Sprintf("Evidence file must not exceed %d bytes", uploads.maxBytes))
+}
+
+func isUploadTooLarge(err error) bool {
+	var maxBytes *http.MaxBytesError
+	return errors.Is(err, errUploadTooLarge) || errors.As(err, &maxBytes)
+}
+
+// uploadLimitReader fails once more than limit bytes have been read
+type uploadLimitReader struct {
+	reader io.Reader
+	limit  int64
+	read   int64
+}
+
+func (r *uploadLimitReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.read += int64(n)
+	if r.read > r.limit {
+		return n, errUploadTooLarge
+	}
+	return n, err
+}
+
+// storeAndAnchorEvidence writes the file to the evidence store and submits CreateEvidence with its hash
+func storeAndAnchorEvidence(c *gin.Context, req UploadEvidenceRequest, mediaType string, file io.Reader) {
+	ctx := c.Request.Context()
+	key := evidenceObjectKey(req.IncidentID, req.EvidenceID)
+
+	hasher := sha256.New()
+	counter := &countingReader{reader: io.TeeReader(file, hasher)}
+	var body io.Reader = counter
+	storedType := mediaType
+
+	// With encryption the store only sees ciphertext; the anchored hash is still of the plaintext
+	var keyRef string
+	if evidenceKeys != nil {
+		ref, aead, err := evidenceKeys.current(ctx, req.IncidentID)
+		if err != nil {
+			logWithContext(ctx, "Failed to get data key for incident %s: %v", req.IncidentID, err)
+			respondError(c, http.StatusServiceUnavailable, errCodeEvidenceKeyUnavailable, "Failed to get the incident's evidence data key")
+			return
+		}
+		if body, err = newEncryptingReader(counter, aead, key); err != nil {
+			respondError(c, http.StatusInternalServerError, errCodeInternal, "Failed to encrypt evidence file")
+			return
+		}
+		keyRef, storedType = ref, "application/octet-stream"
+	}
+
+	if err := evidenceStore.Put(ctx, key, body, -1, storedType); err != nil {
+		if isUploadTooLarge(err) {
+			evidenceStore.Delete(ctx, key)
+			respondUploadTooLarge(c)
+			return
+		}
+		logWithContext(ctx, "Failed to store evidence %s: %v", key, err)
+		respondError(c, http.StatusInternalServerError, errCodeInternal, "Failed to store evidence file")
+		return
+	}
+	if counter.count == 0 {
+		evidenceStore.Delete(ctx, key)
+		respondValidationErrors(c, ValidationErrors{{Field: uploadFileField, Message: "must not be empty"}})
+		return
+	}
+
+	evidenceHash := hex.EncodeToString(hasher.Sum(nil))
+
+	// Content-addressed stores also anchor the CID so the file can be located from the ledger alone
+	var cid string
+	if addressed, ok := evidenceStore.(ContentAddressed); ok {
+		var err error
+		if cid, err = addressed.ContentID(ctx, key); err != nil {
+			evidenceStore.Delete(ctx, key)
+			logWithContext(ctx, "Failed to resolve content ID for %s: %v", key, err)
+			respondError(c, http.StatusInternalServerError, errCodeInternal, "Failed to resolve evidence content ID")
+			return
+		}
+	}
+
+	var result *TransactionResult
+	var err error
+	switch {
+	case keyRef != "":
+		result, err = submitTransaction(ctx, "CreateEncryptedEvidence", req.EvidenceID, evidenceHash, req.IncidentID, mediaType, req.UploadedBy, cid, keyRef)
+	case cid != "":
+		result, err = submitTransaction(ctx, "CreateEvidenceWithCID", req.EvidenceID, evidenceHash, req.IncidentID, mediaType, req.UploadedBy, cid)
+	default:
+		result, err = submitTransaction(ctx, "CreateEvidence", req.EvidenceID, evidenceHash, req.IncidentID, mediaType, req.UploadedBy)
+	}
+	if err != nil {
+		// Do not keep a binary the ledger has no record of
+		if delErr := evidenceStore.Delete(ctx, key); delErr != nil {
+			logWithContext(ctx, "Failed to remove orphaned evidence %s: %v", key, delErr)
+		}
+		respondFabricError(c, "Failed to create evidence", err)
+		return
+	}
+
+	data := gin.H{
+		"message":      "Evidence uploaded successfully",
+		"evidenceID":   req.EvidenceID,
+		"evidenceHash": evidenceHash,
+		"mediaType":    mediaType,
+		"size":         counter.count,
+		"storageKey":   key,
+	}
+	if cid != "" {
+		data["cid"] = cid
+	}
+	if keyRef != "" {
+		data["encryptionKeyRef"] = keyRef
+	}
+	if media != nil && len(media.steps(mediaType)) > 0 {
+		data["mediaQueued"] = media.queue(EvidenceDocument{EvidenceID: req.EvidenceID, EvidenceHash: evidenceHash, IncidentID: req.IncidentID, MediaType: mediaType, EncryptionKeyRef: keyRef})
+	}
+	respondCommitted(c, http.StatusCreated, data, result)
+}
+
+func partMediaType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(contentType))
+	}
+	return mediaType
+}
+
+// countingReader tracks how many bytes have passed through it
+type countingReader struct {
+	reader io.Reader
+	count  int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.count += int64(n)
+	return n, err
+}
@@ -0,0 +1,163 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type stubScanner struct {
+	verdict scanVerdict
+	err     error
+	scanned []byte
+}
+
+func (s *stubScanner) name() string { return "stub" }
+
+func (s *stubScanner) scan(_ context.Context, file io.Reader) (scanVerdict, error) {
+	s.scanned, _ = io.ReadAll(file)
+	return s.verdict, s.err
+}
+
+func TestEvidenceUploadPolicy(t *testing.T) {
+	png := append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, 64)...)
+	for name, tc := range map[string]struct {
+		head []byte
+		want string
+	}{
+		"jpeg":      {[]byte("\xff\xd8\xff\xe0\x00\x10JFIF"), "image/jpeg"},
+		"png":       {png, "image/png"},
+		"pdf":       {[]byte("%PDF-1.7\n"), "application/pdf"},
+		"wav":       {[]byte("RIFF\x24\x00\x00\x00WAVEfmt "), "audio/wav"},
+		"text":      {[]byte("FIR statement of the complainant"), "text/plain"},
+		"quicktime": {[]byte("\x00\x00\x00\x14ftypqt  \x00\x00\x00\x00"), "video/quicktime"},
+		"m4a":       {[]byte("\x00\x00\x00\x18ftypM4A \x00\x00\x00\x00"), "audio/mp4"},
+		"heic":      {[]byte("\x00\x00\x00\x18ftypheic\x00\x00\x00\x00"), "image/heic"},
+		"mp4":       {[]byte("\x00\x00\x00\x18ftypisom\x00\x00\x02\x00"), "video/mp4,audio/mp4"},
+		"html":      {[]byte("<html><script>alert(1)</script>"), "text/html"},
+	} {
+		if got := strings.Join(sniffMediaTypes(tc.head), ","); got != tc.want {
+			t.Errorf("%s: expected %s, got %s", name, tc.want, got)
+		}
+	}
+
+	scanner := &stubScanner{verdict: scanVerdict{Signature: "Eicar-Test-Signature"}}
+	uploads = uploadPolicy{maxBytes: 1024, mediaTypes: []string{"image/png", "application/pdf"}, scanner: scanner, spoolDir: t.TempDir()}
+	defer func() { uploads = uploadPolicy{maxBytes: 100 << 20, mediaTypes: allowedMediaTypes} }()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/evidence/upload", uploadEvidence)
+	upload := func(mediaType string, content []byte) (int, APIResponse) {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		form.WriteField("evidenceID", "ev-1")
+		form.WriteField("incidentID", "inc-1")
+		form.WriteField("uploadedBy", "officer")
+		part, _ := form.CreatePart(textproto.MIMEHeader{
+			"Content-Disposition": {`form-data; name="file"; filename="evidence"`},
+			"Content-Type":        {mediaType},
+		})
+		part.Write(content)
+		form.Close()
+		req := httptest.NewRequest(http.MethodPost, "/evidence/upload", &body)
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var response APIResponse
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w.Code, response
+	}
+
+	for name, tc := range map[string]struct {
+		mediaType string
+		content   []byte
+		status    int
+		code      string
+	}{
+		"type not allowed here":  {"video/mp4", []byte("\x00\x00\x00\x18ftypisom\x00\x00\x02\x00"), http.StatusBadRequest, errCodeValidation},
+		"declared type is a lie": {"image/png", []byte("%PDF-1.7\n"), http.StatusBadRequest, errCodeValidation},
+		"empty":                  {"image/png", nil, http.StatusBadRequest, errCodeValidation},
+		"too large":              {"image/png", append(png, make([]byte, 2048)...), http.StatusRequestEntityTooLarge, errCodePayloadTooLarge},
+		"infected":               {"application/pdf", []byte("%PDF-1.7\nX5O!P%@AP"), http.StatusUnprocessableEntity, errCodeEvidenceInfected},
+	} {
+		status, response := upload(tc.mediaType, tc.content)
+		if status != tc.status || response.Error == nil || response.Error.Code != tc.code {
+			t.Errorf("%s: expected %d %s, got %d %+v", name, tc.status, tc.code, status, response.Error)
+		}
+	}
+	if string(scanner.scanned) != "%PDF-1.7\nX5O!P%@AP" {
+		t.Errorf("expected the whole file scanned, got %q", scanner.scanned)
+	}
+
+	scanner.err = io.ErrUnexpectedEOF
+	if status, response := upload("application/pdf", []byte("%PDF-1.7\n")); status != http.StatusServiceUnavailable || response.Error.Code != errCodeScannerUnavailable {
+		t.Errorf("expected uploads refused while the scanner is down, got %d %+v", status, response.Error)
+	}
+}
+
+func TestClamdScanner(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	received := make(chan []byte, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		command := make([]byte, len("zINSTREAM\x00"))
+		io.ReadFull(conn, command)
+		var stream []byte
+		for {
+			size := make([]byte, 4)
+			if _, err := io.ReadFull(conn, size); err != nil {
+				return
+			}
+			n := binary.BigEndian.Uint32(size)
+			if n == 0 {
+				break
+			}
+			chunk := make([]byte, n)
+			io.ReadFull(conn, chunk)
+			stream = append(stream, chunk...)
+		}
+		received <- append(command, stream...)
+		conn.Write([]byte("stream: Eicar-Test-Signature FOUND\x00"))
+	}()
+
+	scanner := &clamdScanner{addr: listener.Addr().String(), timeout: 5 * time.Second}
+	file := bytes.Repeat([]byte("x"), clamdChunkSize+10)
+	verdict, err := scanner.scan(context.Background(), bytes.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verdict.Clean || verdict.Signature != "Eicar-Test-Signature" {
+		t.Errorf("expected the signature reported, got %+v", verdict)
+	}
+	if got := <-received; string(got) != "zINSTREAM\x00"+string(file) {
+		t.Errorf("expected the file streamed in chunks after INSTREAM, got %d bytes", len(got))
+	}
+
+	if verdict, err := parseClamdReply("stream: OK"); err != nil || !verdict.Clean {
+		t.Errorf("expected a clean verdict, got %+v %v", verdict, err)
+	}
+	if _, err := parseClamdReply("INSTREAM size limit exceeded. ERROR"); err == nil {
+		t.Error("expected a clamd error to be reported")
+	}
+}
@@ -0,0 +1,306 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/hex"
+	"math/big"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"github.com/hyperledger/fabric-protos-go-apiv2/msp"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"google.golang.org/protobuf/proto"
+)
+
+// BlockInfo is a decoded block header with a summary of each transaction it contains
+type BlockInfo struct {
+	Number       uint64         `json:"number"`
+	Hash         string         `json:"hash"`
+	PreviousHash string         `json:"previous_hash"`
+	DataHash     string         `json:"data_hash"`
+	TxCount      int            `json:"tx_count"`
+	Transactions []LedgerTxInfo `json:"transactions"`
+}
+
+// LedgerTxInfo describes a transaction as recorded in a block, including whether the peers
+// accepted it. Invalid transactions are stored in blocks but their writes are discarded.
+type LedgerTxInfo struct {
+	TxID           string `json:"tx_id"`
+	BlockNumber    uint64 `json:"block_number"`
+	Type           string `json:"type"`
+	Timestamp      string `json:"timestamp,omitempty"`
+	CreatorMSPID   string `json:"creator_msp_id,omitempty"`
+	Chaincode      string `json:"chaincode,omitempty"`
+	Function       string `json:"function,omitempty"`
+	ValidationCode string `json:"validation_code"`
+	Valid          bool   `json:"valid"`
+}
+
+// TransactionStatus reports whether a transaction has committed and whether the peers accepted it
+type TransactionStatus struct {
+	TxID           string `json:"tx_id"`
+	Committed      bool   `json:"committed"`
+	Valid          bool   `json:"valid"`
+	ValidationCode string `json:"validation_code,omitempty"`
+	BlockNumber    uint64 `json:"block_number,omitempty"`
+	CheckedAt      string `json:"checked_at"`
+}
+
+// GetBlock reads a block by number through the qscc system chaincode
+func (ledgerService) GetBlock(ctx context.Context, number uint64) (BlockInfo, error) {
+	block, err := readBlock(ctx, number)
+	if err != nil {
+		return BlockInfo{}, err
+	}
+	return decodeBlock(block), nil
+}
+
+// readBlock reads a block by number as stored, for callers that check its hashes
+func readBlock(ctx context.Context, number uint64) (*common.Block, error) {
+	result, err := evaluateSystemTransaction(ctx, qsccName, "GetBlockByNumber", targetFromContext(ctx).Channel, strconv.FormatUint(number, 10))
+	if err != nil {
+		return nil, err
+	}
+
+	var block common.Block
+	if err := proto.Unmarshal(result, &block); err != nil {
+		return nil, &malformedDocumentError{kind: "block", err: err}
+	}
+	return &block, nil
+}
+
+// readBlockByTxID reads the block that recorded a transaction, as stored
+func readBlockByTxID(ctx context.Context, txID string) (*common.Block, error) {
+	result, err := evaluateSystemTransaction(ctx, qsccName, "GetBlockByTxID", targetFromContext(ctx).Channel, txID)
+	if err != nil {
+		return nil, err
+	}
+
+	var block common.Block
+	if err := proto.Unmarshal(result, &block); err != nil {
+		return nil, &malformedDocumentError{kind: "block", err: err}
+	}
+	return &block, nil
+}
+
+// GetLedgerTransaction looks up a transaction by ID and the block that recorded it
+func (ledgerService) GetLedgerTransaction(ctx context.Context, txID string) (LedgerTxInfo, error) {
+	var v fieldValidator
+	v.sha256("txid", txID)
+	if len(v.errors) > 0 {
+		return LedgerTxInfo{}, v.errors
+	}
+
+	result, err := evaluateSystemTransaction(ctx, qsccName, "GetTransactionByID", targetFromContext(ctx).Channel, txID)
+	if err != nil {
+		return LedgerTxInfo{}, err
+	}
+	var processed peer.ProcessedTransaction
+	if err := proto.Unmarshal(result, &processed); err != nil {
+		return LedgerTxInfo{}, &malformedDocumentError{kind: "transaction", err: err}
+	}
+
+	block, err := readBlockByTxID(ctx, txID)
+	if err != nil {
+		return LedgerTxInfo{}, err
+	}
+
+	info := decodeEnvelope(processed.GetTransactionEnvelope())
+	info.BlockNumber = block.GetHeader().GetNumber()
+	info.ValidationCode = peer.TxValidationCode(processed.GetValidationCode()).String()
+	info.Valid = processed.GetValidationCode() == int32(peer.TxValidationCode_VALID)
+	return info, nil
+}
+
+// TransactionStatus looks up the commit status of a transaction through the gateway. A transaction
+// that does not commit within COMMIT_STATUS_WAIT is reported as not committed: it may still be in
+// flight, or it may never have reached the orderer.
+func (ledgerService) TransactionStatus(ctx context.Context, txID string) (TransactionStatus, error) {
+	var v fieldValidator
+	v.sha256("txid", txID)
+	if len(v.errors) > 0 {
+		return TransactionStatus{}, v.errors
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, getEnvDuration("COMMIT_STATUS_WAIT", 2*time.Second))
+	defer cancel()
+
+	result := TransactionStatus{TxID: txID}
+	status, err := commitStatus(waitCtx, txID)
+	result.CheckedAt = time.Now().UTC().Format(time.RFC3339)
+	if err != nil {
+		if ctx.Err() == nil && waitCtx.Err() != nil {
+			return result, nil
+		}
+		return TransactionStatus{}, err
+	}
+
+	result.Committed = true
+	result.Valid = status.Successful
+	result.ValidationCode = status.Code.String()
+	result.BlockNumber = status.BlockNumber
+	return result, nil
+}
+
+// blockHeaderHash computes the hash that the next block records as its previous hash
+func blockHeaderHash(header *common.BlockHeader) []byte {
+	encoded, _ := asn1.Marshal(struct {
+		Number       *big.Int
+		PreviousHash []byte
+		DataHash     []byte
+	}{new(big.Int).SetUint64(header.GetNumber()), header.GetPreviousHash(), header.GetDataHash()})
+	sum := sha256.Sum256(encoded)
+	return sum[:]
+}
+
+// blockDataHash computes the hash of the block's transactions that its header records as data hash
+func blockDataHash(data *common.BlockData) []byte {
+	sum := sha256.Sum256(bytes.Join(data.GetData(), nil))
+	return sum[:]
+}
+
+func decodeBlock(block *common.Block) BlockInfo {
+	header := block.GetHeader()
+	info := BlockInfo{
+		Number:       header.GetNumber(),
+		Hash:         hex.EncodeToString(blockHeaderHash(header)),
+		PreviousHash: hex.EncodeToString(header.GetPreviousHash()),
+		DataHash:     hex.EncodeToString(header.GetDataHash()),
+		TxCount:      len(block.GetData().GetData()),
+		Transactions: []LedgerTxInfo{},
+	}
+
+	for i, data := range block.GetData().GetData() {
+		var envelope common.Envelope
+		if err := proto.Unmarshal(data, &envelope); err != nil {
+			continue
+		}
+		tx := decodeEnvelope(&envelope)
+		tx.BlockNumber = info.Number
+		code := txValidationCode(block, i)
+		tx.ValidationCode = code.String()
+		tx.Valid = code == peer.TxValidationCode_VALID
+		info.Transactions = append(info.Transactions, tx)
+	}
+	return info
+}
+
+// txValidationCode returns the peers' verdict on the block's i-th transaction. Blocks delivered
+// before validation have no filter; their transactions are reported as not yet validated.
+func txValidationCode(block *common.Block, i int) peer.TxValidationCode {
+	var filter []byte
+	if metadata := block.GetMetadata().GetMetadata(); len(metadata) > int(common.BlockMetadataIndex_TRANSACTIONS_FILTER) {
+		filter = metadata[common.BlockMetadataIndex_TRANSACTIONS_FILTER]
+	}
+	if i < len(filter) {
+		return peer.TxValidationCode(filter[i])
+	}
+	return peer.TxValidationCode_NOT_VALIDATED
+}
+
+// decodeEnvelope extracts what it can from a transaction envelope; fields that cannot be decoded
+// are left empty rather than failing the whole lookup
+func decodeEnvelope(envelope *common.Envelope) LedgerTxInfo {
+	var info LedgerTxInfo
+	var payload common.Payload
+	if err := proto.Unmarshal(envelope.GetPayload(), &payload); err != nil {
+		return info
+	}
+
+	var channelHeader common.ChannelHeader
+	if err := proto.Unmarshal(payload.GetHeader().GetChannelHeader(), &channelHeader); err == nil {
+		info.TxID = channelHeader.GetTxId()
+		info.Type = common.HeaderType(channelHeader.GetType()).String()
+		if ts := channelHeader.GetTimestamp(); ts != nil {
+			info.Timestamp = ts.AsTime().UTC().Format(time.RFC3339Nano)
+		}
+	}
+
+	var signatureHeader common.SignatureHeader
+	if err := proto.Unmarshal(payload.GetHeader().GetSignatureHeader(), &signatureHeader); err == nil {
+		var creator msp.SerializedIdentity
+		if err := proto.Unmarshal(signatureHeader.GetCreator(), &creator); err == nil {
+			info.CreatorMSPID = creator.GetMspid()
+		}
+	}
+
+	if channelHeader.GetType() == int32(common.HeaderType_ENDORSER_TRANSACTION) {
+		info.Chaincode, info.Function = decodeInvocation(payload.GetData())
+	}
+	return info
+}
+
+// decodeInvocation returns the chaincode and function named by an endorser transaction
+func decodeInvocation(data []byte) (string, string) {
+	var transaction peer.Transaction
+	if err := proto.Unmarshal(data, &transaction); err != nil || len(transaction.GetActions()) == 0 {
+		return "", ""
+	}
+	var actionPayload peer.ChaincodeActionPayload
+	if err := proto.Unmarshal(transaction.GetActions()[0].GetPayload(), &actionPayload); err != nil {
+		return "", ""
+	}
+	var proposalPayload peer.ChaincodeProposalPayload
+	if err := proto.Unmarshal(actionPayload.GetChaincodeProposalPayload(), &proposalPayload); err != nil {
+		return "", ""
+	}
+	var invocation peer.ChaincodeInvocationSpec
+	if err := proto.Unmarshal(proposalPayload.GetInput(), &invocation); err != nil {
+		return "", ""
+	}
+
+	spec := invocation.GetChaincodeSpec()
+	function := ""
+	if args := spec.GetInput().GetArgs(); len(args) > 0 {
+		function = string(args[0])
+	}
+	return spec.GetChaincodeId().GetName(), function
+}
+
+func getBlock(c *gin.Context) {
+	number, err := strconv.ParseUint(c.Param("number"), 10, 64)
+	if err != nil {
+		respondValidationErrors(c, ValidationErrors{{Field: "number", Message: "must be a non-negative block number"}})
+		return
+	}
+
+	block, err := ledger.GetBlock(c.Request.Context(), number)
+	if err != nil {
+		respondServiceError(c, "Failed to get block", err)
+		return
+	}
+
+	respondData(c, http.StatusOK, block)
+}
+
+func getLedgerTransaction(c *gin.Context) {
+	tx, err := ledger.GetLedgerTransaction(c.Request.Context(), c.Param("txid"))
+	if err != nil {
+		respondServiceError(c, "Failed to get transaction", err)
+		return
+	}
+
+	respondData(c, http.StatusOK, tx)
+}
+
+func getTransactionStatus(c *gin.Context) {
+	status, err := ledger.TransactionStatus(c.Request.Context(), c.Param("txid"))
+	if err != nil {
+		respondServiceError(c, "Failed to get transaction status", err)
+		return
+	}
+
+	respondData(c, http.StatusOK, status)
+}
@@ -0,0 +1,95 @@
+package gateway
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"github.com/hyperledger/fabric-protos-go-apiv2/msp"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func mustMarshal(t *testing.T, m proto.Message) []byte {
+	t.Helper()
+	b, err := proto.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func testEnvelope(t *testing.T, txID, function string, at time.Time) []byte {
+	invocation := mustMarshal(t, &peer.ChaincodeInvocationSpec{ChaincodeSpec: &peer.ChaincodeSpec{
+		ChaincodeId: &peer.ChaincodeID{Name: "basic"},
+		Input:       &peer.ChaincodeInput{Args: [][]byte{[]byte(function), []byte("arg")}},
+	}})
+	actionPayload := mustMarshal(t, &peer.ChaincodeActionPayload{
+		ChaincodeProposalPayload: mustMarshal(t, &peer.ChaincodeProposalPayload{Input: invocation}),
+	})
+	payload := mustMarshal(t, &common.Payload{
+		Header: &common.Header{
+			ChannelHeader: mustMarshal(t, &common.ChannelHeader{
+				Type:      int32(common.HeaderType_ENDORSER_TRANSACTION),
+				TxId:      txID,
+				Timestamp: timestamppb.New(at),
+			}),
+			SignatureHeader: mustMarshal(t, &common.SignatureHeader{
+				Creator: mustMarshal(t, &msp.SerializedIdentity{Mspid: "Org1MSP"}),
+			}),
+		},
+		Data: mustMarshal(t, &peer.Transaction{Actions: []*peer.TransactionAction{{Payload: actionPayload}}}),
+	})
+	return mustMarshal(t, &common.Envelope{Payload: payload})
+}
+
+func TestDecodeBlock(t *testing.T) {
+	at := time.Date(2025, 9, 20, 13, 40, 0, 0, time.UTC)
+	block := &common.Block{
+		Header: &common.BlockHeader{Number: 42, PreviousHash: []byte{0xab}, DataHash: []byte{0xcd}},
+		Data:   &common.BlockData{Data: [][]byte{testEnvelope(t, "tx1", "CreateIncident", at), testEnvelope(t, "tx2", "CreateDID", at)}},
+		Metadata: &common.BlockMetadata{Metadata: [][]byte{nil, nil,
+			{byte(peer.TxValidationCode_VALID), byte(peer.TxValidationCode_MVCC_READ_CONFLICT)}}},
+	}
+
+	info := decodeBlock(block)
+	if info.Number != 42 || info.PreviousHash != "ab" || info.DataHash != "cd" || info.TxCount != 2 {
+		t.Fatalf("unexpected header %+v", info)
+	}
+	if info.Hash != hex.EncodeToString(blockHeaderHash(block.Header)) || len(info.Hash) != 64 {
+		t.Errorf("unexpected block hash %q", info.Hash)
+	}
+
+	first, second := info.Transactions[0], info.Transactions[1]
+	if first.TxID != "tx1" || first.BlockNumber != 42 || first.Type != "ENDORSER_TRANSACTION" || first.CreatorMSPID != "Org1MSP" ||
+		first.Chaincode != "basic" || first.Function != "CreateIncident" || first.Timestamp != "2025-09-20T13:40:00Z" || !first.Valid {
+		t.Errorf("unexpected first transaction %+v", first)
+	}
+	if second.ValidationCode != "MVCC_READ_CONFLICT" || second.Valid {
+		t.Errorf("expected an invalid second transaction, got %+v", second)
+	}
+}
+
+func TestDecodeBlockWithoutFilter(t *testing.T) {
+	block := &common.Block{
+		Header: &common.BlockHeader{Number: 1},
+		Data:   &common.BlockData{Data: [][]byte{testEnvelope(t, "tx1", "CreateDID", time.Now())}},
+	}
+
+	info := decodeBlock(block)
+	if len(info.Transactions) != 1 || info.Transactions[0].ValidationCode != "NOT_VALIDATED" || info.Transactions[0].Valid {
+		t.Errorf("unexpected transactions %+v", info.Transactions)
+	}
+}
+
+func TestTransactionStatusRejectsMalformedID(t *testing.T) {
+	_, err := ledger.TransactionStatus(context.Background(), "not-a-tx-id")
+	var validationErrs ValidationErrors
+	if !errors.As(err, &validationErrs) || validationErrs[0].Field != "txid" {
+		t.Fatalf("expected a txid validation error, got %v", err)
+	}
+}
@@ -0,0 +1,396 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	xlsxContentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	exportPageSize  = maxPageSize
+)
+
+var exportFormats = []string{"csv", "xlsx"}
+
+// Trailers sent after an NDJSON export, so a client can tell a complete download from a cut one
+const (
+	exportCompleteTrailer = "X-Export-Complete"
+	exportCountTrailer    = "X-Export-Count"
+	maxProjectedFields    = 32
+)
+
+// exportDocTypes are the document types GET /export/:docType streams
+var exportDocTypes = []string{"did", "incident", "evidence", "efir", "timeline_annexure", "incident_archive", "sla_breach", "sos", "location_anchor", "safety_anchor", "advisory_anchor", "report_anchor", "erasure", "assignment", "fir_allocation", "zone", "stay", "permit", "claim_reference", "lost_found_item", "lost_found_match", "case_anchor", "device", "device_binding", "call", "cctv_manifest", "sos_trigger", "access_grant", "broadcast", "model_decision", "erss_exchange", "evidence_derivative", "audit"}
+
+// documentOwners read the owning organization of the document types tenancy scopes; the others
+// are visible to every tenant, as they are through their read endpoints
+var documentOwners = map[string]func(doc []byte) string{
+	"incident": ownerOrgOf,
+	"evidence": ownerOrgOf,
+	"sos":      ownerOrgOf,
+	// Annexures and archives are only readable through their incident
+	"timeline_annexure": ownerOrgOf,
+	"incident_archive":  ownerOrgOf,
+	"sla_breach":        ownerOrgOf,
+	// Claim references are also readable by those who can read their incident, which an export
+	// cannot check document by document
+	"claim_reference": ownerOrgOf,
+	// Items are shared for matching, but an export would also carry the reporter's DID
+	"lost_found_item": ownerOrgOf,
+	"erss_exchange":   ownerOrgOf,
+	// Derivatives are only readable through their evidence
+	"evidence_derivative": ownerOrgOf,
+	"audit": func(doc []byte) string {
+		var audit AuditDocument
+		json.Unmarshal(doc, &audit)
+		return auditOwner(audit)
+	},
+}
+
+func ownerOrgOf(doc []byte) string {
+	var owned struct {
+		OwnerOrg string `json:"owner_org"`
+	}
+	json.Unmarshal(doc, &owned)
+	return owned.OwnerOrg
+}
+
+// DocumentPage is a page of documents exactly as stored on the ledger
+type DocumentPage struct {
+	Documents []json.RawMessage
+	Bookmark  string
+}
+
+// tableWriter streams rows of a report in one output format
+type tableWriter interface {
+	WriteRow(values []string) error
+	// Flush pushes buffered rows to the client so large exports stream page by page
+	Flush() error
+	Close() error
+}
+
+// csvTableWriter writes RFC 4180 CSV
+type csvTableWriter struct {
+	w *csv.Writer
+}
+
+func (t *csvTableWriter) WriteRow(values []string) error {
+	cells := make([]string, len(values))
+	for i, value := range values {
+		cells[i] = csvCell(value)
+	}
+	return t.w.Write(cells)
+}
+
+// csvCell keeps a spreadsheet from evaluating a cell as a formula. Text starting with =, +, - or @
+// is prefixed with a quote; numbers, such as negative coordinates, are left as they are.
+func csvCell(value string) string {
+	if value == "" || !strings.ContainsRune("=+-@", rune(value[0])) {
+		return value
+	}
+	if _, err := strconv.ParseFloat(value, 64); err == nil {
+		return value
+	}
+	return "'" + value
+}
+
+func (t *csvTableWriter) Flush() error {
+	t.w.Flush()
+	return t.w.Error()
+}
+
+func (t *csvTableWriter) Close() error {
+	return t.Flush()
+}
+
+// xlsxTableWriter writes a single-sheet Office Open XML workbook. The zip is written straight to
+// the response with rows added to the sheet as they arrive, so nothing is buffered in full.
+type xlsxTableWriter struct {
+	zip   *zip.Writer
+	sheet io.Writer
+	rows  int
+}
+
+func newXLSXTableWriter(w io.Writer, sheetName string) (*xlsxTableWriter, error) {
+	zw := zip.NewWriter(w)
+	parts := []struct{ name, body string }{
+		{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/></Types>`},
+		{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`},
+		{"xl/workbook.xml", fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="%s" sheetId="1" r:id="rId1"/></sheets></workbook>`, sheetName)},
+		{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/></Relationships>`},
+	}
+	for _, part := range parts {
+		f, err := zw.Create(part.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(f, part.body); err != nil {
+			return nil, err
+		}
+	}
+
+	sheet, err := zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.WriteString(sheet, `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	return &xlsxTableWriter{zip: zw, sheet: sheet}, err
+}
+
+func (t *xlsxTableWriter) WriteRow(values []string) error {
+	t.rows++
+	var row strings.Builder
+	fmt.Fprintf(&row, `<row r="%d">`, t.rows)
+	for i, value := range values {
+		fmt.Fprintf(&row, `<c r="%s%d" t="inlineStr"><is><t>`, xlsxColumn(i), t.rows)
+		xml.EscapeText(&row, []byte(strings.Map(xmlChar, value)))
+		row.WriteString(`</t></is></c>`)
+	}
+	row.WriteString(`</row>`)
+	_, err := io.WriteString(t.sheet, row.String())
+	return err
+}
+
+func (t *xlsxTableWriter) Flush() error {
+	return t.zip.Flush()
+}
+
+func (t *xlsxTableWriter) Close() error {
+	if _, err := io.WriteString(t.sheet, `</sheetData></worksheet>`); err != nil {
+		return err
+	}
+	return t.zip.Close()
+}
+
+// xmlChar drops the control characters XML 1.0 cannot carry, even escaped, which would otherwise
+// make the workbook unreadable
+func xmlChar(r rune) rune {
+	if r < 0x20 && r != '\t' && r != '\n' && r != '\r' || r == 0xFFFE || r == 0xFFFF {
+		return -1
+	}
+	return r
+}
+
+// xlsxColumn converts a zero-based index to a column name: A, B, ..., Z, AA, ...
+func xlsxColumn(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
+
+// exportPage fetches one page of rows and the bookmark for the next, empty when done
+type exportPage func(bookmark string) (rows [][]string, next string, err error)
+
+// streamExport writes a report page by page. The first page is fetched before any output so that
+// validation and ledger failures still produce a normal error response; a failure after that can
+// only truncate the download, which is logged.
+func streamExport(c *gin.Context, name, format string, header []string, fetch exportPage) {
+	rows, next, err := fetch("")
+	if err != nil {
+		respondServiceError(c, "Failed to export "+name, err)
+		return
+	}
+
+	if format == "" {
+		format = "csv"
+	}
+	filename := fmt.Sprintf("%s-%s.%s", name, time.Now().UTC().Format("20060102T150405Z"), format)
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	var table tableWriter
+	if format == "xlsx" {
+		c.Header("Content-Type", xlsxContentType)
+		table, err = newXLSXTableWriter(c.Writer, strings.ToUpper(name[:1])+name[1:])
+	} else {
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		table = &csvTableWriter{w: csv.NewWriter(c.Writer)}
+	}
+	c.Status(http.StatusOK)
+	if err == nil {
+		err = table.WriteRow(header)
+	}
+
+	ctx := c.Request.Context()
+	for err == nil {
+		for _, row := range rows {
+			if err = table.WriteRow(row); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = table.Flush()
+		}
+		c.Writer.Flush()
+		if err != nil || next == "" {
+			break
+		}
+		rows, next, err = fetch(next)
+	}
+	if err != nil {
+		logWithContext(ctx, "Export of %s truncated: %v", name, err)
+		return
+	}
+	if err := table.Close(); err != nil {
+		logWithContext(ctx, "Export of %s truncated: %v", name, err)
+	}
+}
+
+// exportIncidents streams every incident in the period matching the optional filters
+func exportIncidents(c *gin.Context) {
+	var req IncidentExportRequest
+	if !bindQuery(c, &req) {
+		return
+	}
+	search := IncidentSearchRequest{From: req.From, To: req.To, Status: req.Status, Severity: req.Severity,
+		Reporter: req.Reporter, PageSize: exportPageSize}
+
+	header := []string{"incident_id", "created_at", "status", "severity", "reporter", "incident_summary_hash", "tx_id"}
+	streamExport(c, "incidents", req.Format, header, func(bookmark string) ([][]string, string, error) {
+		search.Bookmark = bookmark
+		page, err := ledger.SearchIncidents(c.Request.Context(), search)
+		if err != nil {
+			return nil, "", err
+		}
+		rows := make([][]string, 0, len(page.Incidents))
+		for _, incident := range page.Incidents {
+			rows = append(rows, []string{incident.IncidentID, incident.CreatedAt, incident.Status, incident.Severity,
+				incident.Reporter, incident.IncidentSummaryHash, incident.TxID})
+		}
+		return rows, page.Bookmark, nil
+	})
+}
+
+// exportAudits streams every audit entry in the period matching the optional filters
+func exportAudits(c *gin.Context) {
+	var req AuditExportRequest
+	if !bindQuery(c, &req) {
+		return
+	}
+	search := AuditSearchRequest{Actor: req.Actor, Action: req.Action, From: req.From, To: req.To, PageSize: exportPageSize}
+
+	header := []string{"timestamp", "actor", "submitter", "action", "target_id", "audit_hash", "tx_id"}
+	streamExport(c, "audits", req.Format, header, func(bookmark string) ([][]string, string, error) {
+		search.Bookmark = bookmark
+		page, err := ledger.SearchAudits(c.Request.Context(), search)
+		if err != nil {
+			return nil, "", err
+		}
+		rows := make([][]string, 0, len(page.Audits))
+		for _, audit := range page.Audits {
+			rows = append(rows, []string{audit.Timestamp, audit.Actor, audit.Submitter, audit.Action, audit.TargetID, audit.AuditHash, audit.TxID})
+		}
+		return rows, page.Bookmark, nil
+	})
+}
+
+// exportDocuments streams every document of a type as NDJSON, one ledger page at a time, so the
+// gateway holds a single page however large the dataset. The trailers report whether the export
+// ran to the end and how many documents it wrote.
+func exportDocuments(c *gin.Context) {
+	docType := c.Param("docType")
+	if !slices.Contains(exportDocTypes, docType) {
+		respondValidationErrors(c, ValidationErrors{{Field: "docType", Message: "must be one of " + strings.Join(exportDocTypes, ", ")}})
+		return
+	}
+	var req DocumentExportRequest
+	if !bindQuery(c, &req) {
+		return
+	}
+	project := newFieldProjection(req)
+
+	ctx := c.Request.Context()
+	page, err := ledger.ExportDocuments(ctx, docType, "")
+	if err != nil {
+		respondServiceError(c, "Failed to export "+docType+" documents", err)
+		return
+	}
+
+	filename := fmt.Sprintf("%s-%s.ndjson", docType, time.Now().UTC().Format("20060102T150405Z"))
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	c.Header("Trailer", exportCompleteTrailer+", "+exportCountTrailer)
+	c.Status(http.StatusOK)
+
+	count := 0
+	for err == nil {
+		for _, doc := range page.Documents {
+			if err = writeNDJSONLine(c.Writer, project(doc)); err != nil {
+				break
+			}
+			count++
+		}
+		c.Writer.Flush()
+		if err != nil || page.Bookmark == "" {
+			break
+		}
+		page, err = ledger.ExportDocuments(ctx, docType, page.Bookmark)
+	}
+	c.Writer.Header().Set(exportCountTrailer, strconv.Itoa(count))
+	c.Writer.Header().Set(exportCompleteTrailer, strconv.FormatBool(err == nil))
+	if err != nil {
+		logWithContext(ctx, "Export of %s documents truncated after %d: %v", docType, count, err)
+	}
+}
+
+func writeNDJSONLine(w io.Writer, doc []byte) error {
+	if _, err := w.Write(doc); err != nil {
+		return err
+	}
+	_, err := w.Write([]byte{'\n'})
+	return err
+}
+
+// newFieldProjection returns a function that keeps only the included fields of a document, or
+// drops the excluded ones. Without either, documents are written as stored.
+func newFieldProjection(req DocumentExportRequest) func(doc json.RawMessage) []byte {
+	include, exclude := splitList(req.Include), splitList(req.Exclude)
+	if len(include) == 0 && len(exclude) == 0 {
+		return func(doc json.RawMessage) []byte { return doc }
+	}
+	return func(doc json.RawMessage) []byte {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(doc, &fields); err != nil {
+			return doc
+		}
+		if len(include) > 0 {
+			kept := make(map[string]json.RawMessage, len(include))
+			for _, name := range include {
+				if value, ok := fields[name]; ok {
+					kept[name] = value
+				}
+			}
+			fields = kept
+		}
+		for _, name := range exclude {
+			delete(fields, name)
+		}
+		projected, err := json.Marshal(fields)
+		if err != nil {
+			return doc
+		}
+		return projected
+	}
+}
@@ -0,0 +1,155 @@
+package gateway
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// pagedExport serves the given pages in order, linking them with numeric bookmarks
+func pagedExport(pages ...[][]string) exportPage {
+	return func(bookmark string) ([][]string, string, error) {
+		i := 0
+		if bookmark != "" {
+			i = int(bookmark[0] - '0')
+		}
+		next := ""
+		if i+1 < len(pages) {
+			next = string(rune('0' + i + 1))
+		}
+		return pages[i], next, nil
+	}
+}
+
+func runExport(t *testing.T, format string, fetch exportPage) *httptest.ResponseRecorder {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/v1/incident/export", nil)
+	streamExport(c, "incidents", format, []string{"incident_id", "status"}, fetch)
+	return w
+}
+
+func TestStreamExportCSV(t *testing.T) {
+	w := runExport(t, "", pagedExport(
+		[][]string{{"inc_1", "open"}, {"inc_2", "closed"}},
+		[][]string{{"inc_3", `says "hi", twice`}, {"-91.8933", `=HYPERLINK("http://evil.example")`}},
+	))
+
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/csv") {
+		t.Fatalf("unexpected response %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(w.Header().Get("Content-Disposition"), ".csv") {
+		t.Errorf("unexpected disposition %q", w.Header().Get("Content-Disposition"))
+	}
+	records, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 5 || records[0][0] != "incident_id" || records[3][1] != `says "hi", twice` {
+		t.Errorf("unexpected records %q", records)
+	}
+	// Formulas are quoted so a spreadsheet shows them as text, while numbers stay numbers
+	if records[4][0] != "-91.8933" || records[4][1] != `'=HYPERLINK("http://evil.example")` {
+		t.Errorf("expected the formula cell quoted, got %q", records[4])
+	}
+}
+
+func TestStreamExportXLSX(t *testing.T) {
+	w := runExport(t, "xlsx", pagedExport(
+		[][]string{{"inc_1", "open"}},
+		[][]string{{"inc_2", "<b>&\x01\x1b"}},
+	))
+
+	if w.Header().Get("Content-Type") != xlsxContentType {
+		t.Fatalf("unexpected content type %q", w.Header().Get("Content-Type"))
+	}
+	archive, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := map[string]string{}
+	for _, f := range archive.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(r)
+		parts[f.Name] = string(body)
+	}
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels"} {
+		if _, ok := parts[name]; !ok {
+			t.Errorf("missing part %s", name)
+		}
+	}
+	sheet := parts["xl/worksheets/sheet1.xml"]
+	// Control characters XML cannot carry are dropped
+	for _, want := range []string{`<c r="A1" t="inlineStr"><is><t>incident_id</t>`, `<row r="3">`, `<c r="B3" t="inlineStr"><is><t>&lt;b&gt;&amp;</t>`, `</sheetData></worksheet>`} {
+		if !strings.Contains(sheet, want) {
+			t.Errorf("sheet missing %s: %s", want, sheet)
+		}
+	}
+	if err := xml.Unmarshal([]byte(sheet), new(struct{})); err != nil {
+		t.Errorf("sheet is not well-formed XML: %v", err)
+	}
+}
+
+func TestXLSXColumn(t *testing.T) {
+	for i, want := range map[int]string{0: "A", 25: "Z", 26: "AA", 51: "AZ", 52: "BA", 701: "ZZ", 702: "AAA"} {
+		if got := xlsxColumn(i); got != want {
+			t.Errorf("xlsxColumn(%d) = %s, want %s", i, got, want)
+		}
+	}
+}
+
+func TestIncidentExportRequestValidate(t *testing.T) {
+	valid := IncidentExportRequest{From: "2025-01-01T00:00:00Z", To: "2025-02-01T00:00:00Z", Severity: "high", Format: "xlsx"}
+	if errs := valid.Validate(); len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	invalid := IncidentExportRequest{From: "2025-01-01T00:00:00Z", To: "2025-02-01T00:00:00Z", Format: "pdf"}
+	if errs := invalid.Validate(); len(errs) != 1 || errs[0].Field != "format" {
+		t.Fatalf("expected a format error, got %v", errs)
+	}
+}
+
+func TestDocumentExportProjection(t *testing.T) {
+	doc := []byte(`{"doc_type":"incident","incident_id":"i1","reporter":"officer_1","owner_org":"Org2MSP"}`)
+	cases := []struct {
+		req      DocumentExportRequest
+		expected string
+	}{
+		{DocumentExportRequest{}, string(doc)},
+		{DocumentExportRequest{Include: "incident_id, owner_org,missing"}, `{"incident_id":"i1","owner_org":"Org2MSP"}`},
+		{DocumentExportRequest{Exclude: "reporter,doc_type"}, `{"incident_id":"i1","owner_org":"Org2MSP"}`},
+	}
+	for _, tc := range cases {
+		if got := string(newFieldProjection(tc.req)(doc)); got != tc.expected {
+			t.Errorf("%+v: got %s, expected %s", tc.req, got, tc.expected)
+		}
+	}
+
+	if errs := (DocumentExportRequest{Include: "incident_id", Exclude: "reporter"}).Validate(); len(errs) != 1 {
+		t.Errorf("expected include and exclude together rejected, got %v", errs)
+	}
+	if errs := (DocumentExportRequest{Include: "IncidentID"}).Validate(); len(errs) != 1 {
+		t.Errorf("expected a camelCase field rejected, got %v", errs)
+	}
+
+	if owner := documentOwners["incident"](doc); owner != "Org2MSP" {
+		t.Errorf("expected the incident owned by Org2MSP, got %q", owner)
+	}
+	if owner := documentOwners["audit"]([]byte(`{"submitter":"Org1MSP/gateway"}`)); owner != "Org1MSP" {
+		t.Errorf("expected the audit owned by Org1MSP, got %q", owner)
+	}
+}
@@ -0,0 +1,225 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"time"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	gatewaypb "github.com/hyperledger/fabric-protos-go-apiv2/gateway"
+	"github.com/hyperledger/fabric-protos-go-apiv2/msp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	evaluateTimeout     = 5 * time.Second
+	endorseTimeout      = 15 * time.Second
+	submitTimeout       = 5 * time.Second
+	commitStatusTimeout = 1 * time.Minute
+)
+
+// evaluateTransaction runs a query against the chaincode inside a client span
+func evaluateTransaction(ctx context.Context, name string, args ...string) ([]byte, error) {
+	target := targetFromContext(ctx)
+	start := time.Now()
+	result, err := evaluateContract(ctx, target.contractFor(ctx), target.Chaincode, name, args...)
+	if canary != nil {
+		canary.observe(target, err, time.Since(start))
+	}
+	return result, err
+}
+
+// evaluateSystemTransaction runs a query against a system chaincode such as qscc
+func evaluateSystemTransaction(ctx context.Context, systemChaincode, name string, args ...string) ([]byte, error) {
+	return evaluateContract(ctx, targetFromContext(ctx).networkFor(ctx).GetContract(systemChaincode), systemChaincode, name, args...)
+}
+
+func evaluateContract(ctx context.Context, target *client.Contract, chaincode, name string, args ...string) ([]byte, error) {
+	if err := fabricBreaker.allow(time.Now()); err != nil {
+		return nil, err
+	}
+
+	ctx, span := startSpan(ctx, "fabric.evaluate "+name, trace.SpanKindClient)
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("fabric.channel", targetFromContext(ctx).Channel),
+		attribute.String("fabric.chaincode", chaincode),
+		attribute.String("fabric.transaction", name),
+	)
+
+	ctx, cancel := context.WithTimeout(ctx, evaluateTimeout)
+	defer cancel()
+
+	result, err := target.EvaluateWithContext(ctx, name, client.WithArguments(args...))
+	fabricBreaker.record(err, time.Now())
+	fabricMonitor.recordCall(callEvaluate, name, err, time.Now())
+	recordSpanError(span, err)
+	return result, err
+}
+
+// submitTransaction endorses, submits and waits for commit of a transaction through the circuit breaker
+func submitTransaction(ctx context.Context, name string, args ...string) (*TransactionResult, error) {
+	if err := fabricBreaker.allow(time.Now()); err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	result, err := runSubmit(ctx, name, args...)
+	fabricBreaker.record(err, time.Now())
+	fabricMonitor.recordCall(callSubmit, name, err, time.Now())
+	if canary != nil {
+		canary.observe(targetFromContext(ctx), err, time.Since(start))
+	}
+	return result, err
+}
+
+// runSubmit performs the submit stages, recording a span for each
+func runSubmit(ctx context.Context, name string, args ...string) (*TransactionResult, error) {
+	ctx, span := startSpan(ctx, "fabric.submit "+name, trace.SpanKindClient)
+	defer span.End()
+	target := targetFromContext(ctx)
+	span.SetAttributes(
+		attribute.String("fabric.channel", target.Channel),
+		attribute.String("fabric.chaincode", target.Chaincode),
+		attribute.String("fabric.transaction", name),
+	)
+
+	proposal, err := target.contractFor(ctx).NewProposal(name, client.WithArguments(args...))
+	if err != nil {
+		recordSpanError(span, err)
+		return nil, err
+	}
+	span.SetAttributes(attribute.String("fabric.tx_id", proposal.TransactionID()))
+
+	transaction, err := endorse(ctx, proposal)
+	if err != nil {
+		recordSpanError(span, err)
+		return nil, err
+	}
+
+	commit, err := submit(ctx, transaction)
+	if err != nil {
+		recordSpanError(span, err)
+		return nil, err
+	}
+	submittedAt := time.Now()
+	fabricMonitor.submitted(target.Name, submittedAt)
+
+	status, err := waitForCommit(ctx, commit)
+	if err != nil {
+		recordSpanError(span, err)
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int64("fabric.block_number", int64(status.BlockNumber)))
+	fabricMonitor.committed(target.Name, status.BlockNumber, time.Since(submittedAt), time.Now())
+
+	return &TransactionResult{
+		Payload:     transaction.Result(),
+		TxID:        status.TransactionID,
+		BlockNumber: status.BlockNumber,
+	}, nil
+}
+
+func endorse(ctx context.Context, proposal *client.Proposal) (*client.Transaction, error) {
+	ctx, span := startSpan(ctx, "fabric.endorse", trace.SpanKindClient)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, endorseTimeout)
+	defer cancel()
+
+	transaction, err := proposal.EndorseWithContext(ctx)
+	recordSpanError(span, err)
+	return transaction, err
+}
+
+func submit(ctx context.Context, transaction *client.Transaction) (*client.Commit, error) {
+	ctx, span := startSpan(ctx, "fabric.submit_to_orderer", trace.SpanKindClient)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, submitTimeout)
+	defer cancel()
+
+	commit, err := transaction.SubmitWithContext(ctx)
+	recordSpanError(span, err)
+	return commit, err
+}
+
+func waitForCommit(ctx context.Context, commit *client.Commit) (*client.Status, error) {
+	ctx, span := startSpan(ctx, "fabric.commit_wait", trace.SpanKindInternal)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, commitStatusTimeout)
+	defer cancel()
+
+	status, err := commit.StatusWithContext(ctx)
+	if err != nil {
+		recordSpanError(span, err)
+		return nil, err
+	}
+
+	span.SetAttributes(attribute.String("fabric.validation_code", status.Code.String()))
+	if !status.Successful {
+		err = &commitError{TxID: status.TransactionID, Code: status.Code}
+		recordSpanError(span, err)
+		return nil, err
+	}
+
+	return status, nil
+}
+
+// commitStatus asks the gateway for the commit status of any transaction on the channel, not only
+// those submitted by this process. It blocks until the transaction commits or ctx is done.
+func commitStatus(ctx context.Context, txID string) (*client.Status, error) {
+	if err := fabricBreaker.allow(time.Now()); err != nil {
+		return nil, err
+	}
+
+	ctx, span := startSpan(ctx, "fabric.commit_status", trace.SpanKindClient)
+	defer span.End()
+	channel := targetFromContext(ctx).Channel
+	span.SetAttributes(
+		attribute.String("fabric.channel", channel),
+		attribute.String("fabric.tx_id", txID),
+	)
+
+	commit, err := newCommit(gatewayFromContext(ctx), channel, txID)
+	if err != nil {
+		recordSpanError(span, err)
+		return nil, err
+	}
+
+	status, err := commit.StatusWithContext(ctx)
+	if ctx.Err() != nil {
+		// Running out of time to wait says nothing about the peers' health
+		fabricBreaker.record(nil, time.Now())
+	} else {
+		fabricBreaker.record(err, time.Now())
+	}
+	recordSpanError(span, err)
+	return status, err
+}
+
+// newCommit builds an unsigned commit status request for txID; gw signs it on first use
+func newCommit(gw *client.Gateway, channel, txID string) (*client.Commit, error) {
+	id := gw.Identity()
+	creator, err := proto.Marshal(&msp.SerializedIdentity{Mspid: id.MspID(), IdBytes: id.Credentials()})
+	if err != nil {
+		return nil, err
+	}
+	request, err := proto.Marshal(&gatewaypb.CommitStatusRequest{TransactionId: txID, ChannelId: channel, Identity: creator})
+	if err != nil {
+		return nil, err
+	}
+	signed, err := proto.Marshal(&gatewaypb.SignedCommitStatusRequest{Request: request})
+	if err != nil {
+		return nil, err
+	}
+	return gw.NewCommit(signed)
+}
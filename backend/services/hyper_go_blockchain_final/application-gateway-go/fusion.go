@@ -0,0 +1,493 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	maxWiFiObservations = 50
+	maxCellObservations = 10
+	// missingRSSI stands in for an access point one side of a comparison did not hear
+	missingRSSI = -100
+	// minCellAccuracy and defaultCellRange bound cell estimates, whose towers often lack a range
+	minCellAccuracy  = 100.0
+	defaultCellRange = 1000.0
+	minWiFiAccuracy  = 10.0
+
+	errCodeFusionDisabled     = "FUSION_DISABLED"
+	errCodeLocationUnresolved = "LOCATION_UNRESOLVED"
+)
+
+// Sources of a position estimate
+const (
+	fixGPS  = "gps"
+	fixWiFi = "wifi"
+	fixCell = "cell"
+)
+
+var bssidRegex = regexp.MustCompile(`^[0-9A-Fa-f]{2}(:[0-9A-Fa-f]{2}){5}$`)
+
+// WiFiObservation is an access point the device heard, with its signal strength in dBm
+type WiFiObservation struct {
+	BSSID string `json:"bssid"`
+	RSSI  int    `json:"rssi"`
+}
+
+// CellObservation is a cell the device was registered on or heard. Area is the LAC or TAC.
+type CellObservation struct {
+	MCC    int   `json:"mcc"`
+	MNC    int   `json:"mnc"`
+	Area   int   `json:"area"`
+	CellID int64 `json:"cellId"`
+	Signal *int  `json:"signal,omitempty"`
+}
+
+func validateRadioObservations(v *fieldValidator, field func(string) string, wifi []WiFiObservation, cells []CellObservation) {
+	if len(wifi) > maxWiFiObservations {
+		v.add(field("wifi"), "must contain at most %d access points", maxWiFiObservations)
+	}
+	for i, ap := range wifi {
+		if !bssidRegex.MatchString(ap.BSSID) {
+			v.add(field(fmt.Sprintf("wifi[%d].bssid", i)), "must be a MAC address such as a4:2b:b0:12:34:56")
+		}
+		if ap.RSSI < -120 || ap.RSSI > 0 {
+			v.add(field(fmt.Sprintf("wifi[%d].rssi", i)), "must be between -120 and 0 dBm")
+		}
+	}
+	if len(cells) > maxCellObservations {
+		v.add(field("cells"), "must contain at most %d cells", maxCellObservations)
+	}
+	for i, cell := range cells {
+		if cell.MCC < 1 || cell.MCC > 999 || cell.MNC < 0 || cell.MNC > 999 {
+			v.add(field(fmt.Sprintf("cells[%d]", i)), "must have an mcc between 1 and 999 and an mnc between 0 and 999")
+		}
+		if cell.Area < 0 || cell.CellID < 0 {
+			v.add(field(fmt.Sprintf("cells[%d]", i)), "must not have a negative area or cellId")
+		}
+		if cell.Signal != nil && (*cell.Signal < -150 || *cell.Signal > 0) {
+			v.add(field(fmt.Sprintf("cells[%d].signal", i)), "must be between -150 and 0 dBm")
+		}
+	}
+}
+
+// LocationEstimateRequest is what a device observed at one moment: a GPS fix, when it has one, and
+// the Wi-Fi access points and cells around it
+type LocationEstimateRequest struct {
+	Latitude  *float64          `json:"latitude"`
+	Longitude *float64          `json:"longitude"`
+	Accuracy  float64           `json:"accuracy"`
+	WiFi      []WiFiObservation `json:"wifi"`
+	Cells     []CellObservation `json:"cells"`
+}
+
+func (r LocationEstimateRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	field := func(name string) string { return name }
+	if (r.Latitude == nil) != (r.Longitude == nil) {
+		v.add("latitude", "must be given together with longitude")
+	}
+	if r.Latitude != nil && (*r.Latitude < -90 || *r.Latitude > 90 || *r.Longitude < -180 || *r.Longitude > 180) {
+		v.add("latitude", "must be a valid coordinate with longitude")
+	}
+	if r.Accuracy < 0 {
+		v.add("accuracy", "must not be negative")
+	}
+	if r.Latitude == nil && len(r.WiFi) == 0 && len(r.Cells) == 0 {
+		v.add("wifi", "or cells are required without latitude and longitude")
+	}
+	validateRadioObservations(&v, field, r.WiFi, r.Cells)
+	return v.errors
+}
+
+// PositionFix is one source's estimate of a position, with its accuracy radius in meters
+type PositionFix struct {
+	Source    string  `json:"source"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Accuracy  float64 `json:"accuracy"`
+	// Used is false for a fix too far from the most accurate one to describe the same position
+	Used bool `json:"used"`
+}
+
+// LocationEstimate is the best estimate of a position from every source that could place it.
+// Source names the sources used, such as gps+wifi.
+type LocationEstimate struct {
+	Latitude  float64       `json:"latitude"`
+	Longitude float64       `json:"longitude"`
+	Accuracy  float64       `json:"accuracy"`
+	Source    string        `json:"source"`
+	Fixes     []PositionFix `json:"fixes"`
+}
+
+type cellKey struct {
+	mcc, mnc, area int
+	cell           int64
+}
+
+type cellTower struct {
+	latitude, longitude, rangeM float64
+}
+
+// wifiFingerprint is a surveyed point and the access points heard there
+type wifiFingerprint struct {
+	latitude, longitude float64
+	rssi                map[string]int
+}
+
+// locationFusion places a device from GPS, Wi-Fi fingerprints and cell towers together, so that
+// tourists stay located indoors and in gorges where GPS fails. Reference data is loaded at start.
+type locationFusion struct {
+	towers       map[cellKey]cellTower
+	fingerprints []wifiFingerprint
+	byBSSID      map[string][]int
+	neighbours   int
+	maxAccuracy  float64
+}
+
+var fusion *locationFusion
+
+// initFusion loads the reference data named by FUSION_CELL_TOWERS_FILE and
+// FUSION_WIFI_FINGERPRINTS_FILE. Without either, pings must carry GPS coordinates.
+func initFusion() {
+	towersFile := getEnv("FUSION_CELL_TOWERS_FILE", "")
+	fingerprintsFile := getEnv("FUSION_WIFI_FINGERPRINTS_FILE", "")
+	if towersFile == "" && fingerprintsFile == "" {
+		log.Println("📶 FUSION_CELL_TOWERS_FILE and FUSION_WIFI_FINGERPRINTS_FILE not set, pings need GPS coordinates")
+		return
+	}
+	f := &locationFusion{
+		towers:      map[cellKey]cellTower{},
+		byBSSID:     map[string][]int{},
+		neighbours:  getEnvInt("FUSION_WIFI_NEIGHBOURS", 3),
+		maxAccuracy: getEnvFloat("FUSION_MAX_ACCURACY", 5000),
+	}
+	if f.neighbours < 1 || f.maxAccuracy <= 0 {
+		panic(errors.New("FUSION_WIFI_NEIGHBOURS and FUSION_MAX_ACCURACY must be positive"))
+	}
+	var mccs []int
+	for _, mcc := range splitList(getEnv("FUSION_CELL_MCC", "")) {
+		n, err := strconv.Atoi(mcc)
+		if err != nil {
+			panic(fmt.Errorf("FUSION_CELL_MCC lists %q, which is not a country code", mcc))
+		}
+		mccs = append(mccs, n)
+	}
+	if towersFile != "" {
+		if err := loadReference(towersFile, func(r io.Reader) error { return f.loadTowers(r, mccs) }); err != nil {
+			panic(fmt.Errorf("failed to load FUSION_CELL_TOWERS_FILE: %w", err))
+		}
+	}
+	if fingerprintsFile != "" {
+		if err := loadReference(fingerprintsFile, f.loadFingerprints); err != nil {
+			panic(fmt.Errorf("failed to load FUSION_WIFI_FINGERPRINTS_FILE: %w", err))
+		}
+	}
+	fusion = f
+	log.Printf("📶 Fusing GPS with %d cell towers and %d Wi-Fi fingerprints", len(f.towers), len(f.fingerprints))
+}
+
+func loadReference(path string, load func(io.Reader) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return load(bufio.NewReader(file))
+}
+
+// loadTowers reads cell towers in the OpenCellID CSV layout: radio, mcc, net, area, cell, unit,
+// lon, lat, range and further columns, with or without the header. mccs, when given, keeps only
+// the towers of those countries.
+func (f *locationFusion) loadTowers(r io.Reader, mccs []int) error {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.ReuseRecord = true
+	for line := 1; ; line++ {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if line == 1 && record[0] == "radio" {
+			continue
+		}
+		if len(record) < 9 {
+			return fmt.Errorf("line %d: expected at least 9 columns", line)
+		}
+		var key cellKey
+		var tower cellTower
+		var errs [7]error
+		key.mcc, errs[0] = strconv.Atoi(record[1])
+		key.mnc, errs[1] = strconv.Atoi(record[2])
+		key.area, errs[2] = strconv.Atoi(record[3])
+		key.cell, errs[3] = strconv.ParseInt(record[4], 10, 64)
+		tower.longitude, errs[4] = strconv.ParseFloat(record[6], 64)
+		tower.latitude, errs[5] = strconv.ParseFloat(record[7], 64)
+		tower.rangeM, errs[6] = strconv.ParseFloat(record[8], 64)
+		if err := errors.Join(errs[:]...); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		if len(mccs) > 0 && !slices.Contains(mccs, key.mcc) {
+			continue
+		}
+		if tower.rangeM <= 0 {
+			tower.rangeM = defaultCellRange
+		}
+		f.towers[key] = tower
+	}
+}
+
+// loadFingerprints reads surveyed points as JSON lines, such as
+// {"latitude":25.57,"longitude":91.88,"wifi":[{"bssid":"a4:2b:b0:12:34:56","rssi":-61}]}
+func (f *locationFusion) loadFingerprints(r io.Reader) error {
+	dec := json.NewDecoder(r)
+	for line := 1; ; line++ {
+		var point LocationEstimateRequest
+		if err := dec.Decode(&point); errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("point %d: %w", line, err)
+		}
+		if point.Latitude == nil || point.Longitude == nil || len(point.WiFi) == 0 {
+			return fmt.Errorf("point %d: expected latitude, longitude and wifi", line)
+		}
+		fp := wifiFingerprint{latitude: *point.Latitude, longitude: *point.Longitude, rssi: map[string]int{}}
+		for _, ap := range point.WiFi {
+			bssid := strings.ToLower(ap.BSSID)
+			if _, seen := fp.rssi[bssid]; !seen {
+				f.byBSSID[bssid] = append(f.byBSSID[bssid], len(f.fingerprints))
+			}
+			fp.rssi[bssid] = ap.RSSI
+		}
+		f.fingerprints = append(f.fingerprints, fp)
+	}
+}
+
+// wifiFix matches the access points heard against the surveyed fingerprints and averages the
+// nearest in signal space, weighted by how closely they match
+func (f *locationFusion) wifiFix(observed []WiFiObservation) (PositionFix, bool) {
+	heard := make(map[string]int, len(observed))
+	for _, ap := range observed {
+		heard[strings.ToLower(ap.BSSID)] = ap.RSSI
+	}
+	shared := map[int]int{}
+	for bssid := range heard {
+		for _, i := range f.byBSSID[bssid] {
+			shared[i]++
+		}
+	}
+	type match struct {
+		index    int
+		distance float64
+	}
+	var matches []match
+	for i, n := range shared {
+		if n < min(2, len(heard)) {
+			continue
+		}
+		fp := f.fingerprints[i]
+		sum, count := 0.0, 0
+		for bssid, rssi := range heard {
+			stored, ok := fp.rssi[bssid]
+			if !ok {
+				stored = missingRSSI
+			}
+			sum += float64((rssi - stored) * (rssi - stored))
+			count++
+		}
+		for bssid, stored := range fp.rssi {
+			if _, ok := heard[bssid]; !ok {
+				sum += float64((missingRSSI - stored) * (missingRSSI - stored))
+				count++
+			}
+		}
+		matches = append(matches, match{i, math.Sqrt(sum / float64(count))})
+	}
+	if len(matches) == 0 {
+		return PositionFix{}, false
+	}
+	slices.SortFunc(matches, func(a, b match) int {
+		if a.distance != b.distance {
+			return cmp.Compare(a.distance, b.distance)
+		}
+		return a.index - b.index
+	})
+	matches = matches[:min(f.neighbours, len(matches))]
+
+	points := make([]PositionFix, len(matches))
+	for i, m := range matches {
+		fp := f.fingerprints[m.index]
+		points[i] = PositionFix{Latitude: fp.latitude, Longitude: fp.longitude, Accuracy: 1 + m.distance}
+	}
+	weights := make([]float64, len(points))
+	for i, p := range points {
+		weights[i] = 1 / p.Accuracy
+	}
+	lat, lng, spread := weightedCentre(points, weights)
+	// A poor best match in signal space widens the radius: every 10 dB off adds the spread again
+	accuracy := math.Max(spread, minWiFiAccuracy) * (1 + matches[0].distance/10)
+	return PositionFix{Source: fixWiFi, Latitude: lat, Longitude: lng, Accuracy: accuracy}, true
+}
+
+// cellFix places the device among the known towers it observed, weighting small cells and strong
+// signals more
+func (f *locationFusion) cellFix(observed []CellObservation) (PositionFix, bool) {
+	var towers []PositionFix
+	var weights []float64
+	for _, cell := range observed {
+		tower, ok := f.towers[cellKey{cell.MCC, cell.MNC, cell.Area, cell.CellID}]
+		if !ok {
+			continue
+		}
+		weight := 1 / tower.rangeM
+		if cell.Signal != nil {
+			// Each 20 dB stronger counts ten times more
+			weight *= math.Pow(10, float64(*cell.Signal+150)/20)
+		}
+		towers = append(towers, PositionFix{Latitude: tower.latitude, Longitude: tower.longitude, Accuracy: tower.rangeM})
+		weights = append(weights, weight)
+	}
+	if len(towers) == 0 {
+		return PositionFix{}, false
+	}
+	lat, lng, spread := weightedCentre(towers, weights)
+	rangeSum, weightSum := 0.0, 0.0
+	for j, tower := range towers {
+		rangeSum += weights[j] * tower.Accuracy
+		weightSum += weights[j]
+	}
+	accuracy := math.Max(math.Max(rangeSum/weightSum/math.Sqrt(float64(len(towers))), spread), minCellAccuracy)
+	return PositionFix{Source: fixCell, Latitude: lat, Longitude: lng, Accuracy: accuracy}, true
+}
+
+// weightedCentre averages points by weight and returns the weighted RMS distance to the centre, in
+// meters. The points are close enough together to average their coordinates.
+func weightedCentre(points []PositionFix, weights []float64) (float64, float64, float64) {
+	lat, lng, total := 0.0, 0.0, 0.0
+	for i, p := range points {
+		lat += weights[i] * p.Latitude
+		lng += weights[i] * p.Longitude
+		total += weights[i]
+	}
+	lat, lng = lat/total, lng/total
+	spread := 0.0
+	for i, p := range points {
+		d := haversineKm(lat, lng, p.Latitude, p.Longitude) * 1000
+		spread += weights[i] * d * d
+	}
+	return lat, lng, math.Sqrt(spread / total)
+}
+
+// estimate fuses every fix that can be made from the observations. Fixes are weighted by the
+// inverse of their variance; one further from the most accurate fix than both their radii allow
+// is left out, since GPS bouncing off gorge walls and stale fingerprints fail that way. The
+// radius never claims better than the fixes' disagreement.
+func (f *locationFusion) estimate(req LocationEstimateRequest) (LocationEstimate, bool) {
+	var fixes []PositionFix
+	if req.Latitude != nil && req.Longitude != nil {
+		fixes = append(fixes, PositionFix{Source: fixGPS, Latitude: *req.Latitude, Longitude: *req.Longitude, Accuracy: math.Max(req.Accuracy, 1)})
+	}
+	if fix, ok := f.wifiFix(req.WiFi); ok {
+		fixes = append(fixes, fix)
+	}
+	if fix, ok := f.cellFix(req.Cells); ok {
+		fixes = append(fixes, fix)
+	}
+	if len(fixes) == 0 {
+		return LocationEstimate{}, false
+	}
+
+	best := slices.MinFunc(fixes, func(a, b PositionFix) int { return cmp.Compare(a.Accuracy, b.Accuracy) })
+	var used []PositionFix
+	var weights []float64
+	var sources []string
+	precision := 0.0
+	for i, fix := range fixes {
+		d := haversineKm(best.Latitude, best.Longitude, fix.Latitude, fix.Longitude) * 1000
+		if d <= 2*(best.Accuracy+fix.Accuracy) {
+			fixes[i].Used = true
+			used = append(used, fix)
+			weights = append(weights, 1/(fix.Accuracy*fix.Accuracy))
+			sources = append(sources, fix.Source)
+			precision += weights[len(weights)-1]
+		}
+	}
+	lat, lng, spread := weightedCentre(used, weights)
+	estimate := LocationEstimate{
+		Latitude:  math.Round(lat*1e6) / 1e6,
+		Longitude: math.Round(lng*1e6) / 1e6,
+		Accuracy:  math.Round(math.Max(1/math.Sqrt(precision), spread)*10) / 10,
+		Source:    strings.Join(sources, "+"),
+		Fixes:     fixes,
+	}
+	return estimate, estimate.Accuracy <= f.maxAccuracy
+}
+
+// resolve places pings that carry Wi-Fi or cell observations, leaving GPS-only pings as they are.
+// Pings without GPS that cannot be placed within FUSION_MAX_ACCURACY are dropped and counted.
+func (f *locationFusion) resolve(pings []LocationPing) ([]LocationPing, int) {
+	if f == nil {
+		return pings, 0
+	}
+	resolved := make([]LocationPing, 0, len(pings))
+	for _, ping := range pings {
+		if len(ping.WiFi) == 0 && len(ping.Cells) == 0 {
+			resolved = append(resolved, ping)
+			continue
+		}
+		estimate, ok := f.estimate(LocationEstimateRequest{Latitude: ping.Latitude, Longitude: ping.Longitude, Accuracy: ping.Accuracy, WiFi: ping.WiFi, Cells: ping.Cells})
+		if !ok {
+			// A GPS fix is kept as it came when nothing better places it
+			if ping.Latitude != nil {
+				resolved = append(resolved, ping)
+			}
+			continue
+		}
+		ping.Latitude, ping.Longitude, ping.Accuracy, ping.Source = &estimate.Latitude, &estimate.Longitude, estimate.Accuracy, estimate.Source
+		resolved = append(resolved, ping)
+	}
+	return resolved, len(pings) - len(resolved)
+}
+
+// estimateLocation answers with the fused position for what a device observed, without recording
+// it, so apps and field staff can check coverage of the reference data
+func estimateLocation(c *gin.Context) {
+	var req LocationEstimateRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+	if fusion == nil {
+		respondError(c, http.StatusServiceUnavailable, errCodeFusionDisabled, "Location fusion is not configured; set FUSION_CELL_TOWERS_FILE or FUSION_WIFI_FINGERPRINTS_FILE")
+		return
+	}
+	estimate, ok := fusion.estimate(req)
+	if !ok {
+		respondError(c, http.StatusUnprocessableEntity, errCodeLocationUnresolved, "No source could place the device within FUSION_MAX_ACCURACY")
+		return
+	}
+	respondData(c, http.StatusOK, estimate)
+}
@@ -0,0 +1,78 @@
+package gateway
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLocationFusion(t *testing.T) {
+	f := &locationFusion{towers: map[cellKey]cellTower{}, byBSSID: map[string][]int{}, neighbours: 3, maxAccuracy: 5000}
+	towers := `radio,mcc,net,area,cell,unit,lon,lat,range,samples,changeable,created,updated,averageSignal
+GSM,404,45,1201,5001,0,91.8850,25.5750,2000,12,1,1459692000,1600000000,0
+LTE,404,45,1201,5002,0,91.8750,25.5650,0,40,1,1459692000,1600000000,0
+LTE,405,1,1,1,0,80.27,13.08,1000,3,1,1459692000,1600000000,0
+`
+	if err := f.loadTowers(strings.NewReader(towers), []int{404}); err != nil {
+		t.Fatal(err)
+	}
+	fingerprints := `{"latitude":25.5700,"longitude":91.8800,"wifi":[{"bssid":"AA:00:00:00:00:01","rssi":-40},{"bssid":"aa:00:00:00:00:02","rssi":-70},{"bssid":"aa:00:00:00:00:03","rssi":-80}]}
+{"latitude":25.5709,"longitude":91.8800,"wifi":[{"bssid":"aa:00:00:00:00:01","rssi":-70},{"bssid":"aa:00:00:00:00:02","rssi":-40},{"bssid":"aa:00:00:00:00:03","rssi":-75}]}
+{"latitude":25.5700,"longitude":91.8810,"wifi":[{"bssid":"aa:00:00:00:00:01","rssi":-75},{"bssid":"aa:00:00:00:00:02","rssi":-72},{"bssid":"aa:00:00:00:00:03","rssi":-40}]}
+{"latitude":25.6000,"longitude":91.9000,"wifi":[{"bssid":"aa:00:00:00:00:09","rssi":-50}]}
+`
+	if err := f.loadFingerprints(strings.NewReader(fingerprints)); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.towers) != 2 || len(f.fingerprints) != 4 {
+		t.Fatalf("expected 2 towers of MCC 404 and 4 fingerprints, got %d and %d", len(f.towers), len(f.fingerprints))
+	}
+
+	nearA := []WiFiObservation{{"aa:00:00:00:00:01", -42}, {"AA:00:00:00:00:02", -69}, {"aa:00:00:00:00:03", -81}}
+	wifi, ok := f.estimate(LocationEstimateRequest{WiFi: nearA})
+	if !ok || wifi.Source != fixWiFi || haversineKm(wifi.Latitude, wifi.Longitude, 25.57, 91.88)*1000 > 60 || wifi.Accuracy < minWiFiAccuracy {
+		t.Errorf("expected a Wi-Fi fix near the first surveyed point, got %+v", wifi)
+	}
+
+	signal := -70
+	cell, ok := f.estimate(LocationEstimateRequest{Cells: []CellObservation{{MCC: 404, MNC: 45, Area: 1201, CellID: 5001, Signal: &signal}, {MCC: 404, MNC: 45, Area: 1201, CellID: 5002}, {MCC: 404, MNC: 45, Area: 1, CellID: 9}}})
+	if !ok || cell.Source != fixCell || cell.Accuracy < minCellAccuracy || cell.Latitude < 25.565 || cell.Latitude > 25.575 {
+		t.Errorf("expected a cell fix between the known towers, got %+v", cell)
+	}
+
+	// An agreeing GPS fix tightens the Wi-Fi estimate
+	lat, lng := 25.5701, 91.8801
+	fused, _ := f.estimate(LocationEstimateRequest{Latitude: &lat, Longitude: &lng, Accuracy: 15, WiFi: nearA})
+	if fused.Source != "gps+wifi" || fused.Accuracy >= 15 {
+		t.Errorf("expected GPS and Wi-Fi fused below the GPS radius, got %+v", fused)
+	}
+	// GPS thrown kilometers off by gorge walls loses to the fingerprints
+	far := 25.6000
+	fused, _ = f.estimate(LocationEstimateRequest{Latitude: &far, Longitude: &lng, Accuracy: 300, WiFi: nearA})
+	if fused.Source != fixWiFi || len(fused.Fixes) != 2 || fused.Fixes[0].Used {
+		t.Errorf("expected the stray GPS fix left out, got %+v", fused)
+	}
+
+	recordedAt := time.Now().UTC().Format(time.RFC3339)
+	pings, unresolved := f.resolve([]LocationPing{
+		{Latitude: &lat, Longitude: &lng, Accuracy: 8, RecordedAt: recordedAt},
+		{WiFi: nearA, RecordedAt: recordedAt},
+		{Cells: []CellObservation{{MCC: 404, MNC: 99, Area: 1, CellID: 1}}, RecordedAt: recordedAt},
+	})
+	if unresolved != 1 || len(pings) != 2 || pings[0].Source != "" || pings[1].Source != fixWiFi || pings[1].Latitude == nil {
+		t.Errorf("expected the GPS ping kept, the Wi-Fi ping placed and the unknown cell dropped, got %+v and %d", pings, unresolved)
+	}
+
+	// Pings may leave out coordinates only when fusion can place them
+	saved := fusion
+	defer func() { fusion = saved }()
+	req := LocationPingsRequest{DigitalID: "did:sih:t1", Pings: []LocationPing{{WiFi: nearA, RecordedAt: recordedAt}}}
+	fusion = nil
+	if errs := req.Validate(); len(errs) != 2 {
+		t.Errorf("expected coordinates required without fusion, got %v", errs)
+	}
+	fusion = f
+	if errs := req.Validate(); len(errs) != 0 {
+		t.Errorf("expected Wi-Fi to stand in for coordinates, got %v", errs)
+	}
+}
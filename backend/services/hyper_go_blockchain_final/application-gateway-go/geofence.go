@@ -0,0 +1,984 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+	_ "time/tzdata" // zone hours name IANA timezones, which slim images do not ship
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+const (
+	maxZoneNameLength = 200
+	maxZoneVertices   = 10000
+	maxZoneRadius     = 50000 // metres
+
+	defaultZoneTimezone = "Asia/Kolkata"
+)
+
+var zoneRiskLevels = []string{"low", "medium", "high", "restricted"}
+
+// GeoJSONGeometry is a GeoJSON Polygon or MultiPolygon in longitude, latitude order, or a Circle
+// whose coordinates are its [longitude, latitude] centre and whose radius is in metres
+type GeoJSONGeometry struct {
+	Type        string      `json:"type" binding:"required"`
+	Coordinates interface{} `json:"coordinates" binding:"required"`
+	Radius      float64     `json:"radius,omitempty"`
+}
+
+// ZoneHours limits a zone to a daily window, such as a trail closed after dark. End may be earlier
+// than start for windows that run past midnight.
+type ZoneHours struct {
+	Start    string `json:"start" binding:"required"`
+	End      string `json:"end" binding:"required"`
+	Timezone string `json:"timezone"`
+}
+
+// CreateZoneRequest registers a geofence zone
+type CreateZoneRequest struct {
+	ZoneID    string           `json:"zoneID" binding:"required"`
+	Name      string           `json:"name" binding:"required"`
+	Geometry  *GeoJSONGeometry `json:"geometry" binding:"required"`
+	RiskLevel string           `json:"riskLevel" binding:"required"`
+	Hours     *ZoneHours       `json:"hours"`
+	Actor     string           `json:"actor" binding:"required"`
+}
+
+func (r CreateZoneRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.identifier("zoneID", r.ZoneID)
+	validateZone(&v, r.Name, r.Geometry, r.RiskLevel, r.Hours)
+	return v.errors
+}
+
+// UpdateZoneRequest replaces a zone's name, geometry, risk level and hours
+type UpdateZoneRequest struct {
+	Name      string           `json:"name" binding:"required"`
+	Geometry  *GeoJSONGeometry `json:"geometry" binding:"required"`
+	RiskLevel string           `json:"riskLevel" binding:"required"`
+	Hours     *ZoneHours       `json:"hours"`
+	Actor     string           `json:"actor" binding:"required"`
+}
+
+func (r UpdateZoneRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	validateZone(&v, r.Name, r.Geometry, r.RiskLevel, r.Hours)
+	return v.errors
+}
+
+func validateZone(v *fieldValidator, name string, geometry *GeoJSONGeometry, riskLevel string, hours *ZoneHours) {
+	if len(name) > maxZoneNameLength {
+		v.add("name", "must be at most %d characters", maxZoneNameLength)
+	}
+	if geometry != nil {
+		if _, _, err := parseZoneGeometry(*geometry); err != nil {
+			v.add("geometry", "%s", err.Error())
+		}
+	}
+	v.oneOf("riskLevel", riskLevel, zoneRiskLevels)
+	if hours != nil {
+		start, startErr := time.Parse("15:04", hours.Start)
+		if startErr != nil {
+			v.add("hours.start", "must be a time of day such as 06:00")
+		}
+		end, endErr := time.Parse("15:04", hours.End)
+		if endErr != nil {
+			v.add("hours.end", "must be a time of day such as 18:30")
+		}
+		if startErr == nil && endErr == nil && start.Equal(end) {
+			v.add("hours.end", "must differ from start; omit hours for a zone that always applies")
+		}
+		if hours.Timezone != "" {
+			if _, err := time.LoadLocation(hours.Timezone); err != nil {
+				v.add("hours.timezone", "must be an IANA timezone such as Asia/Kolkata")
+			}
+		}
+	}
+}
+
+// EvaluateGeofenceRequest asks which zones contain a coordinate
+type EvaluateGeofenceRequest struct {
+	Latitude  *float64 `json:"latitude" binding:"required"`
+	Longitude *float64 `json:"longitude" binding:"required"`
+	// At evaluates zone hours at another time, such as when a queued location was recorded
+	At string `json:"at"`
+}
+
+func (r EvaluateGeofenceRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	if r.Latitude != nil && (*r.Latitude < -90 || *r.Latitude > 90) {
+		v.add("latitude", "must be between -90 and 90")
+	}
+	if r.Longitude != nil && (*r.Longitude < -180 || *r.Longitude > 180) {
+		v.add("longitude", "must be between -180 and 180")
+	}
+	if r.At != "" {
+		v.rfc3339("at", r.At)
+	}
+	return v.errors
+}
+
+// ZoneDocument is a zone as the chaincode stores it, with the geometry as a GeoJSON string
+type ZoneDocument struct {
+	DocType    string `json:"doc_type"`
+	ZoneID     string `json:"zone_id"`
+	Name       string `json:"name"`
+	Geometry   string `json:"geometry"`
+	RiskLevel  string `json:"risk_level"`
+	ActiveFrom string `json:"active_from,omitempty"`
+	ActiveTo   string `json:"active_to,omitempty"`
+	Timezone   string `json:"timezone,omitempty"`
+	UpdatedBy  string `json:"updated_by"`
+	UpdatedAt  string `json:"updated_at"`
+	// The zone's risk as last scored from its incident history
+	RiskScore       float64 `json:"risk_score,omitempty"`
+	ScoredRiskLevel string  `json:"scored_risk_level,omitempty"`
+	ScoredIncidents int     `json:"scored_incidents,omitempty"`
+	ScoredAt        string  `json:"scored_at,omitempty"`
+	TxID            string  `json:"tx_id"`
+}
+
+// Zone is a geofence zone as the API returns it
+type Zone struct {
+	ZoneID    string          `json:"zone_id"`
+	Name      string          `json:"name"`
+	Geometry  GeoJSONGeometry `json:"geometry"`
+	RiskLevel string          `json:"risk_level"`
+	Hours     *ZoneHours      `json:"hours,omitempty"`
+	Risk      *ZoneRiskScore  `json:"risk,omitempty"`
+	UpdatedBy string          `json:"updated_by"`
+	UpdatedAt string          `json:"updated_at"`
+	TxID      string          `json:"tx_id"`
+}
+
+// ZoneMatch is a zone containing an evaluated coordinate. Active is false outside the zone's hours.
+// RaisedBy names the weather alert that raised RiskLevel above the zone's own level, or is
+// incident_history when the zone's risk score did.
+type ZoneMatch struct {
+	ZoneID    string  `json:"zone_id"`
+	Name      string  `json:"name"`
+	RiskLevel string  `json:"risk_level"`
+	RiskScore float64 `json:"risk_score,omitempty"`
+	Active    bool    `json:"active"`
+	RaisedBy  string  `json:"raised_by,omitempty"`
+}
+
+// GeofenceEvaluation lists the zones containing a coordinate, with the highest risk level among the
+// active ones
+type GeofenceEvaluation struct {
+	Latitude    float64     `json:"latitude"`
+	Longitude   float64     `json:"longitude"`
+	EvaluatedAt string      `json:"evaluated_at"`
+	RiskLevel   string      `json:"risk_level,omitempty"`
+	Zones       []ZoneMatch `json:"zones"`
+	// SyncedAt is when the index last loaded every zone from the ledger
+	SyncedAt string `json:"synced_at,omitempty"`
+}
+
+// zoneRing is a closed ring of [longitude, latitude] positions
+type zoneRing [][2]float64
+
+// zonePolygon is an outer ring followed by any holes
+type zonePolygon []zoneRing
+
+type bounds struct {
+	minLng, minLat, maxLng, maxLat float64
+}
+
+func (b bounds) contains(lng, lat float64) bool {
+	return lng >= b.minLng && lng <= b.maxLng && lat >= b.minLat && lat <= b.maxLat
+}
+
+// zoneCircle is a circular zone around a [longitude, latitude] centre
+type zoneCircle struct {
+	lng, lat float64
+	radius   float64 // metres
+}
+
+// indexedZone is a zone with its geometry parsed for evaluation
+type indexedZone struct {
+	zone     Zone
+	polygons []zonePolygon
+	circle   *zoneCircle
+	bbox     bounds
+	location *time.Location
+	start    int // minutes after midnight; start == end means always active
+	end      int
+}
+
+// parseZoneGeometry checks a Polygon or MultiPolygon and converts it to rings, or checks a Circle.
+// Rings must be closed, with at least four positions, and positions must be valid longitude,
+// latitude pairs.
+func parseZoneGeometry(geometry GeoJSONGeometry) ([]zonePolygon, *zoneCircle, error) {
+	raw, err := json.Marshal(geometry.Coordinates)
+	if err != nil {
+		return nil, nil, fmt.Errorf("has invalid coordinates")
+	}
+	if geometry.Type == "Circle" {
+		circle, err := parseZoneCircle(raw, geometry.Radius)
+		return nil, circle, err
+	}
+	if geometry.Radius != 0 {
+		return nil, nil, fmt.Errorf("may only have a radius when it is a Circle")
+	}
+
+	var polygons [][][][]float64
+	switch geometry.Type {
+	case "Polygon":
+		var polygon [][][]float64
+		if err := json.Unmarshal(raw, &polygon); err != nil {
+			return nil, nil, fmt.Errorf("must have Polygon coordinates: an array of rings of [longitude, latitude] positions")
+		}
+		polygons = [][][][]float64{polygon}
+	case "MultiPolygon":
+		if err := json.Unmarshal(raw, &polygons); err != nil {
+			return nil, nil, fmt.Errorf("must have MultiPolygon coordinates: an array of polygons")
+		}
+	default:
+		return nil, nil, fmt.Errorf("must be a GeoJSON Polygon or MultiPolygon, or a Circle")
+	}
+	if len(polygons) == 0 {
+		return nil, nil, fmt.Errorf("must contain at least one polygon")
+	}
+
+	vertices := 0
+	parsed := make([]zonePolygon, 0, len(polygons))
+	for _, polygon := range polygons {
+		if len(polygon) == 0 {
+			return nil, nil, fmt.Errorf("must not contain an empty polygon")
+		}
+		rings := make(zonePolygon, 0, len(polygon))
+		for _, ring := range polygon {
+			if len(ring) < 4 {
+				return nil, nil, fmt.Errorf("must have rings of at least four positions")
+			}
+			points := make(zoneRing, 0, len(ring))
+			for _, position := range ring {
+				if len(position) < 2 {
+					return nil, nil, fmt.Errorf("must have positions of [longitude, latitude]")
+				}
+				lng, lat := position[0], position[1]
+				if lng < -180 || lng > 180 || lat < -90 || lat > 90 {
+					return nil, nil, fmt.Errorf("must have longitudes between -180 and 180 and latitudes between -90 and 90")
+				}
+				points = append(points, [2]float64{lng, lat})
+			}
+			if points[0] != points[len(points)-1] {
+				return nil, nil, fmt.Errorf("must have closed rings whose first and last positions are equal")
+			}
+			vertices += len(points)
+			rings = append(rings, points)
+		}
+		parsed = append(parsed, rings)
+	}
+	if vertices > maxZoneVertices {
+		return nil, nil, fmt.Errorf("must have at most %d positions", maxZoneVertices)
+	}
+	return parsed, nil, nil
+}
+
+func parseZoneCircle(raw []byte, radius float64) (*zoneCircle, error) {
+	var centre []float64
+	if err := json.Unmarshal(raw, &centre); err != nil || len(centre) != 2 {
+		return nil, fmt.Errorf("must have Circle coordinates: a [longitude, latitude] centre")
+	}
+	if centre[0] < -180 || centre[0] > 180 || centre[1] < -90 || centre[1] > 90 {
+		return nil, fmt.Errorf("must have a longitude between -180 and 180 and a latitude between -90 and 90")
+	}
+	if radius <= 0 || radius > maxZoneRadius {
+		return nil, fmt.Errorf("must have a radius between 0 and %d metres", maxZoneRadius)
+	}
+	return &zoneCircle{lng: centre[0], lat: centre[1], radius: radius}, nil
+}
+
+// bbox bounds the circle, widening the longitude span with latitude
+func (c *zoneCircle) bbox() bounds {
+	dLat := c.radius / 111320
+	dLng := 180.0
+	if cos := math.Cos(c.lat * math.Pi / 180); cos > dLat/180 {
+		dLng = math.Min(dLat/cos, 180)
+	}
+	return bounds{math.Max(c.lng-dLng, -180), math.Max(c.lat-dLat, -90), math.Min(c.lng+dLng, 180), math.Min(c.lat+dLat, 90)}
+}
+
+func newIndexedZone(doc ZoneDocument) (*indexedZone, error) {
+	var geometry GeoJSONGeometry
+	if err := json.Unmarshal([]byte(doc.Geometry), &geometry); err != nil {
+		return nil, fmt.Errorf("zone %s has malformed geometry: %w", doc.ZoneID, err)
+	}
+	polygons, circle, err := parseZoneGeometry(geometry)
+	if err != nil {
+		return nil, fmt.Errorf("zone %s geometry %w", doc.ZoneID, err)
+	}
+
+	z := &indexedZone{
+		zone: Zone{
+			ZoneID:    doc.ZoneID,
+			Name:      doc.Name,
+			Geometry:  geometry,
+			RiskLevel: doc.RiskLevel,
+			UpdatedBy: doc.UpdatedBy,
+			UpdatedAt: doc.UpdatedAt,
+			TxID:      doc.TxID,
+		},
+		polygons: polygons,
+		circle:   circle,
+		bbox:     bounds{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)},
+		location: time.UTC,
+	}
+	if circle != nil {
+		z.bbox = circle.bbox()
+	}
+	for _, polygon := range polygons {
+		for _, p := range polygon[0] {
+			z.bbox.minLng, z.bbox.maxLng = math.Min(z.bbox.minLng, p[0]), math.Max(z.bbox.maxLng, p[0])
+			z.bbox.minLat, z.bbox.maxLat = math.Min(z.bbox.minLat, p[1]), math.Max(z.bbox.maxLat, p[1])
+		}
+	}
+
+	if doc.ActiveFrom != "" && doc.ActiveTo != "" {
+		start, err := time.Parse("15:04", doc.ActiveFrom)
+		if err != nil {
+			return nil, fmt.Errorf("zone %s has malformed active_from %q", doc.ZoneID, doc.ActiveFrom)
+		}
+		end, err := time.Parse("15:04", doc.ActiveTo)
+		if err != nil {
+			return nil, fmt.Errorf("zone %s has malformed active_to %q", doc.ZoneID, doc.ActiveTo)
+		}
+		timezone := doc.Timezone
+		if timezone == "" {
+			timezone = defaultZoneTimezone
+		}
+		if z.location, err = time.LoadLocation(timezone); err != nil {
+			return nil, fmt.Errorf("zone %s has unknown timezone %q", doc.ZoneID, timezone)
+		}
+		z.start, z.end = start.Hour()*60+start.Minute(), end.Hour()*60+end.Minute()
+		z.zone.Hours = &ZoneHours{Start: doc.ActiveFrom, End: doc.ActiveTo, Timezone: timezone}
+	}
+	if doc.ScoredAt != "" {
+		z.zone.Risk = &ZoneRiskScore{Score: doc.RiskScore, Level: doc.ScoredRiskLevel, Incidents: doc.ScoredIncidents, ScoredAt: doc.ScoredAt}
+	}
+	return z, nil
+}
+
+func (z *indexedZone) contains(lng, lat float64) bool {
+	if !z.bbox.contains(lng, lat) {
+		return false
+	}
+	if z.circle != nil {
+		return haversineKm(lat, lng, z.circle.lat, z.circle.lng)*1000 <= z.circle.radius
+	}
+	for _, polygon := range z.polygons {
+		if !polygon[0].contains(lng, lat) {
+			continue
+		}
+		inHole := false
+		for _, hole := range polygon[1:] {
+			if hole.contains(lng, lat) {
+				inHole = true
+				break
+			}
+		}
+		if !inHole {
+			return true
+		}
+	}
+	return false
+}
+
+// contains casts a ray east from the point and counts edge crossings
+func (r zoneRing) contains(lng, lat float64) bool {
+	inside := false
+	for i, j := 0, len(r)-1; i < len(r); j, i = i, i+1 {
+		xi, yi, xj, yj := r[i][0], r[i][1], r[j][0], r[j][1]
+		if (yi > lat) != (yj > lat) && lng < (xj-xi)*(lat-yi)/(yj-yi)+xi {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+func (z *indexedZone) activeAt(t time.Time) bool {
+	if z.start == z.end {
+		return true
+	}
+	local := t.In(z.location)
+	minute := local.Hour()*60 + local.Minute()
+	if z.start < z.end {
+		return minute >= z.start && minute < z.end
+	}
+	return minute >= z.start || minute < z.end
+}
+
+// geofenceIndex keeps the default target's zones in memory in an R-tree, so evaluation only tests
+// the zones whose bounding boxes contain the point. It loads every zone at start and every
+// GEOFENCE_SYNC_INTERVAL, and applies zone events in between.
+type geofenceIndex struct {
+	mu       sync.RWMutex
+	zones    map[string]*indexedZone
+	tree     *zoneTree
+	syncedAt time.Time
+	// raises are temporary risk levels from weather alerts, by zone ID. They live outside the zones
+	// so reloading zones from the ledger keeps them.
+	raises map[string]zoneRaise
+}
+
+// zoneRaise lifts a zone to at least level until the alert behind it expires
+type zoneRaise struct {
+	level   string
+	alertID string
+	until   time.Time
+}
+
+var geofence *geofenceIndex
+
+func initGeofence() {
+	geofence = newGeofenceIndex()
+	initZoneImport()
+	initZoneTracker()
+	initAlertRules()
+}
+
+func newGeofenceIndex() *geofenceIndex {
+	return &geofenceIndex{zones: map[string]*indexedZone{}, tree: newZoneTree(), raises: map[string]zoneRaise{}}
+}
+
+// setRaises replaces every temporary risk raise
+func (g *geofenceIndex) setRaises(raises map[string]zoneRaise) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.raises = raises
+}
+
+// match describes a zone at a time, with its risk level raised by its risk score and by any
+// unexpired weather alert
+func (g *geofenceIndex) match(z *indexedZone, at time.Time) ZoneMatch {
+	match := ZoneMatch{ZoneID: z.zone.ZoneID, Name: z.zone.Name, RiskLevel: z.zone.RiskLevel, Active: z.activeAt(at)}
+	if risk := z.zone.Risk; risk != nil {
+		match.RiskScore = risk.Score
+		if zoneRiskLevel(risk.Level) > zoneRiskLevel(match.RiskLevel) {
+			match.RiskLevel, match.RaisedBy = risk.Level, zoneRiskRaisedBy
+		}
+	}
+	g.mu.RLock()
+	raise, ok := g.raises[z.zone.ZoneID]
+	g.mu.RUnlock()
+	if ok && at.Before(raise.until) && zoneRiskLevel(raise.level) > zoneRiskLevel(match.RiskLevel) {
+		match.RiskLevel, match.RaisedBy = raise.level, raise.alertID
+	}
+	return match
+}
+
+// put adds or replaces a zone
+func (g *geofenceIndex) put(z *indexedZone) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.removeLocked(z.zone.ZoneID)
+	g.zones[z.zone.ZoneID] = z
+	g.tree.insert(z)
+}
+
+func (g *geofenceIndex) remove(zoneID string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.removeLocked(zoneID)
+}
+
+func (g *geofenceIndex) removeLocked(zoneID string) {
+	if z, ok := g.zones[zoneID]; ok {
+		delete(g.zones, zoneID)
+		g.tree.remove(z)
+	}
+}
+
+// replace swaps in a full set of zones read from the ledger
+func (g *geofenceIndex) replace(docs []ZoneDocument) {
+	fresh := newGeofenceIndex()
+	for _, doc := range docs {
+		z, err := newIndexedZone(doc)
+		if err != nil {
+			log.Printf("Skipping geofence zone: %v", err)
+			continue
+		}
+		fresh.put(z)
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.zones, g.tree, g.syncedAt = fresh.zones, fresh.tree, time.Now().UTC()
+}
+
+// candidates returns the zones whose bounding boxes intersect box
+func (g *geofenceIndex) candidates(box bounds) []*indexedZone {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	var zones []*indexedZone
+	g.tree.search(box, func(z *indexedZone) { zones = append(zones, z) })
+	return zones
+}
+
+// evaluate returns the zones containing the point, sorted by ID
+func (g *geofenceIndex) evaluate(lat, lng float64, at time.Time) GeofenceEvaluation {
+	result := GeofenceEvaluation{Latitude: lat, Longitude: lng, EvaluatedAt: at.UTC().Format(time.RFC3339), Zones: []ZoneMatch{}}
+
+	g.mu.RLock()
+	if !g.syncedAt.IsZero() {
+		result.SyncedAt = g.syncedAt.Format(time.RFC3339)
+	}
+	g.mu.RUnlock()
+
+	highest := -1
+	for _, z := range g.candidates(bounds{lng, lat, lng, lat}) {
+		if !z.contains(lng, lat) {
+			continue
+		}
+		match := g.match(z, at)
+		result.Zones = append(result.Zones, match)
+		if level := zoneRiskLevel(match.RiskLevel); match.Active && level > highest {
+			highest, result.RiskLevel = level, match.RiskLevel
+		}
+	}
+	sort.Slice(result.Zones, func(i, j int) bool { return result.Zones[i].ZoneID < result.Zones[j].ZoneID })
+	return result
+}
+
+// overlapping returns the zones that share any area with area, sorted by ID, whatever their hours
+func (g *geofenceIndex) overlapping(area *indexedZone) []Zone {
+	zones := []Zone{}
+	for _, z := range g.candidates(area.bbox) {
+		if z.overlaps(area) {
+			zones = append(zones, z.zone)
+		}
+	}
+	sort.Slice(zones, func(i, j int) bool { return zones[i].ZoneID < zones[j].ZoneID })
+	return zones
+}
+
+// overlaps reports whether two zones share any area: a vertex of one lies inside the other, or
+// their edges cross. Circles are compared as 64-sided polygons.
+func (z *indexedZone) overlaps(other *indexedZone) bool {
+	if z.bbox.minLng > other.bbox.maxLng || other.bbox.minLng > z.bbox.maxLng || z.bbox.minLat > other.bbox.maxLat || other.bbox.minLat > z.bbox.maxLat {
+		return false
+	}
+	mine, theirs := z.outline(), other.outline()
+	for _, ring := range mine {
+		for _, p := range ring {
+			if other.contains(p[0], p[1]) {
+				return true
+			}
+		}
+	}
+	for _, ring := range theirs {
+		for _, p := range ring {
+			if z.contains(p[0], p[1]) {
+				return true
+			}
+		}
+	}
+	for _, a := range mine {
+		for _, b := range theirs {
+			if a.crosses(b) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// outline returns the zone's outer rings, approximating a circle by a polygon
+func (z *indexedZone) outline() []zoneRing {
+	if z.circle != nil {
+		const sides = 64
+		dLat := z.circle.radius / 111320
+		dLng := dLat / math.Max(math.Cos(z.circle.lat*math.Pi/180), 1e-6)
+		ring := make(zoneRing, 0, sides+1)
+		for i := range sides + 1 {
+			angle := 2 * math.Pi * float64(i%sides) / sides
+			ring = append(ring, [2]float64{z.circle.lng + dLng*math.Cos(angle), z.circle.lat + dLat*math.Sin(angle)})
+		}
+		return []zoneRing{ring}
+	}
+	rings := make([]zoneRing, 0, len(z.polygons))
+	for _, polygon := range z.polygons {
+		rings = append(rings, polygon[0])
+	}
+	return rings
+}
+
+// crosses reports whether any edge of r properly intersects an edge of other
+func (r zoneRing) crosses(other zoneRing) bool {
+	orientation := func(a, b, c [2]float64) float64 {
+		return (b[0]-a[0])*(c[1]-a[1]) - (b[1]-a[1])*(c[0]-a[0])
+	}
+	for i := 1; i < len(r); i++ {
+		for j := 1; j < len(other); j++ {
+			a, b, c, d := r[i-1], r[i], other[j-1], other[j]
+			if orientation(a, b, c)*orientation(a, b, d) < 0 && orientation(c, d, a)*orientation(c, d, b) < 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// NearbyZone is a zone within reach of a position. Distance is to the edge of a circle and to the
+// bounding box of a polygon, so it is zero inside the zone and may understate the distance to
+// irregular shapes.
+type NearbyZone struct {
+	ZoneMatch
+	Inside     bool    `json:"inside"`
+	DistanceKm float64 `json:"distance_km"`
+}
+
+// nearby returns the zones at or above minRisk within radiusKm of the point, nearest first
+func (g *geofenceIndex) nearby(lat, lng, radiusKm float64, minRisk string, at time.Time) []NearbyZone {
+	result := []NearbyZone{}
+	reach := (&zoneCircle{lng: lng, lat: lat, radius: radiusKm * 1000}).bbox()
+	for _, z := range g.candidates(reach) {
+		match := g.match(z, at)
+		if zoneRiskLevel(match.RiskLevel) < zoneRiskLevel(minRisk) {
+			continue
+		}
+		inside := z.contains(lng, lat)
+		distance := 0.0
+		if !inside && z.circle != nil {
+			distance = math.Max(haversineKm(lat, lng, z.circle.lat, z.circle.lng)-z.circle.radius/1000, 0)
+		} else if !inside {
+			nearestLat := math.Max(z.bbox.minLat, math.Min(lat, z.bbox.maxLat))
+			nearestLng := math.Max(z.bbox.minLng, math.Min(lng, z.bbox.maxLng))
+			distance = haversineKm(lat, lng, nearestLat, nearestLng)
+		}
+		if distance > radiusKm {
+			continue
+		}
+		result = append(result, NearbyZone{
+			ZoneMatch:  match,
+			Inside:     inside,
+			DistanceKm: math.Round(distance*100) / 100,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].DistanceKm != result[j].DistanceKm {
+			return result[i].DistanceKm < result[j].DistanceKm
+		}
+		return result[i].ZoneID < result[j].ZoneID
+	})
+	return result
+}
+
+func zoneRiskLevel(level string) int {
+	for i, candidate := range zoneRiskLevels {
+		if level == candidate {
+			return i
+		}
+	}
+	return -1
+}
+
+// run loads every zone from the default target, retrying until it succeeds, then reloads every
+// GEOFENCE_SYNC_INTERVAL to recover from missed events
+func (g *geofenceIndex) run(ctx context.Context) {
+	interval := getEnvDuration("GEOFENCE_SYNC_INTERVAL", 5*time.Minute)
+	retry := 10 * time.Second
+	for {
+		wait := interval
+		if err := g.sync(ctx); err != nil {
+			log.Printf("Failed to load geofence zones: %v", err)
+			wait = retry
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (g *geofenceIndex) sync(ctx context.Context) error {
+	docs, err := ledger.zoneDocuments(withTarget(ctx, defaultTarget))
+	if err != nil {
+		return err
+	}
+	first := g.lastSync().IsZero()
+	g.replace(docs)
+	if first {
+		log.Printf("🗺️ Loaded %d geofence zones", len(docs))
+	}
+	return nil
+}
+
+func (g *geofenceIndex) lastSync() time.Time {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.syncedAt
+}
+
+// geofenceFromEvent applies zone changes from the default target, including ones made through other
+// gateway instances
+func geofenceFromEvent(ctx context.Context, event *client.ChaincodeEvent) {
+	if geofence == nil || !isDefaultTarget(ctx) {
+		return
+	}
+	var doc ZoneDocument
+	switch event.EventName {
+	case "CreateZone", "UpdateZone", "DeleteZone":
+		if err := json.Unmarshal(event.Payload, &doc); err != nil {
+			return
+		}
+	case "ScoreZones":
+		var docs []ZoneDocument
+		if err := json.Unmarshal(event.Payload, &docs); err != nil {
+			return
+		}
+		for _, doc := range docs {
+			if z, err := newIndexedZone(doc); err == nil {
+				geofence.put(z)
+			}
+		}
+		return
+	default:
+		return
+	}
+
+	if event.EventName == "DeleteZone" {
+		geofence.remove(doc.ZoneID)
+		return
+	}
+	z, err := newIndexedZone(doc)
+	if err != nil {
+		logWithContext(ctx, "Ignoring geofence zone event: %v", err)
+		return
+	}
+	geofence.put(z)
+}
+
+// Zone operations
+
+func (ledgerService) CreateZone(ctx context.Context, req CreateZoneRequest) (*TransactionResult, error) {
+	if errs := req.Validate(); len(errs) > 0 {
+		return nil, errs
+	}
+	return submitZone(ctx, "CreateZone", req.ZoneID, req.Name, *req.Geometry, req.RiskLevel, req.Hours, req.Actor)
+}
+
+func (ledgerService) UpdateZone(ctx context.Context, id string, req UpdateZoneRequest) (*TransactionResult, error) {
+	if err := validateMutation(id, req); err != nil {
+		return nil, err
+	}
+	return submitZone(ctx, "UpdateZone", id, req.Name, *req.Geometry, req.RiskLevel, req.Hours, req.Actor)
+}
+
+// submitZone writes a zone and, for the default target, updates the index straight away rather
+// than waiting for the event
+func submitZone(ctx context.Context, name, id, zoneName string, geometry GeoJSONGeometry, riskLevel string, hours *ZoneHours, actor string) (*TransactionResult, error) {
+	geometryJSON, err := json.Marshal(geometry)
+	if err != nil {
+		return nil, err
+	}
+	doc := ZoneDocument{ZoneID: id, Name: zoneName, Geometry: string(geometryJSON), RiskLevel: riskLevel, UpdatedBy: actor}
+	if hours != nil {
+		doc.ActiveFrom, doc.ActiveTo, doc.Timezone = hours.Start, hours.End, hours.Timezone
+		if doc.Timezone == "" {
+			doc.Timezone = defaultZoneTimezone
+		}
+	}
+
+	result, err := submitAndInvalidate(ctx, id, name, id, zoneName, doc.Geometry, riskLevel, doc.ActiveFrom, doc.ActiveTo, doc.Timezone, actor)
+	if err != nil {
+		return nil, err
+	}
+	if geofence != nil && isDefaultTarget(ctx) {
+		doc.UpdatedAt, doc.TxID = time.Now().UTC().Format(time.RFC3339), result.TxID
+		if z, err := newIndexedZone(doc); err == nil {
+			// The chaincode keeps the zone's score across edits, so the index does too
+			z.zone.Risk = geofence.risk(id)
+			geofence.put(z)
+		}
+	}
+	return result, nil
+}
+
+func (ledgerService) DeleteZone(ctx context.Context, id string, req DeleteRequest) (*TransactionResult, error) {
+	if err := validateMutation(id, req); err != nil {
+		return nil, err
+	}
+	result, err := submitAndInvalidate(ctx, id, "DeleteZone", id, req.Actor)
+	if err != nil {
+		return nil, err
+	}
+	if geofence != nil && isDefaultTarget(ctx) {
+		geofence.remove(id)
+	}
+	return result, nil
+}
+
+func (s ledgerService) GetZone(ctx context.Context, id string) (Zone, error) {
+	result, err := s.readDocument(ctx, "ReadZone", id)
+	if err != nil {
+		return Zone{}, err
+	}
+	doc, err := decodeDocument[ZoneDocument](result, "zone")
+	if err != nil {
+		return Zone{}, err
+	}
+	z, err := newIndexedZone(doc)
+	if err != nil {
+		return Zone{}, &malformedDocumentError{kind: "zone", err: err}
+	}
+	return z.zone, nil
+}
+
+func (ledgerService) ListZones(ctx context.Context) ([]Zone, error) {
+	docs, err := ledger.zoneDocuments(ctx)
+	if err != nil {
+		return nil, err
+	}
+	zones := make([]Zone, 0, len(docs))
+	for _, doc := range docs {
+		z, err := newIndexedZone(doc)
+		if err != nil {
+			logWithContext(ctx, "Skipping geofence zone: %v", err)
+			continue
+		}
+		zones = append(zones, z.zone)
+	}
+	return zones, nil
+}
+
+func (ledgerService) zoneDocuments(ctx context.Context) ([]ZoneDocument, error) {
+	result, err := evaluateTransaction(ctx, "GetAllZones")
+	if err != nil {
+		return nil, err
+	}
+	return decodeDocument[[]ZoneDocument](result, "zone")
+}
+
+// EvaluateGeofence reports the zones containing a coordinate. It reads the in-memory index of the
+// default target's zones, so it never waits on the peers.
+func (ledgerService) EvaluateGeofence(req EvaluateGeofenceRequest) (GeofenceEvaluation, error) {
+	if errs := req.Validate(); len(errs) > 0 {
+		return GeofenceEvaluation{}, errs
+	}
+	at := time.Now()
+	if req.At != "" {
+		at, _ = time.Parse(time.RFC3339, req.At)
+	}
+	return geofence.evaluate(*req.Latitude, *req.Longitude, at), nil
+}
+
+func createZone(c *gin.Context) {
+	var req CreateZoneRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+	setAuditTarget(c, req.ZoneID)
+
+	result, err := ledger.CreateZone(c.Request.Context(), req)
+	if err != nil {
+		respondServiceError(c, "Failed to create zone", err)
+		return
+	}
+
+	respondCommitted(c, http.StatusCreated, gin.H{
+		"message": "Zone created successfully",
+		"zoneID":  req.ZoneID,
+	}, result)
+}
+
+func listZones(c *gin.Context) {
+	zones, err := ledger.ListZones(c.Request.Context())
+	if err != nil {
+		respondServiceError(c, "Failed to list zones", err)
+		return
+	}
+
+	respondData(c, http.StatusOK, zones)
+}
+
+func getZone(c *gin.Context) {
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+
+	zone, err := ledger.GetZone(c.Request.Context(), id)
+	if err != nil {
+		respondServiceError(c, "Failed to read zone", err)
+		return
+	}
+
+	respondData(c, http.StatusOK, zone)
+}
+
+func updateZone(c *gin.Context) {
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	var req UpdateZoneRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+
+	result, err := ledger.UpdateZone(c.Request.Context(), id, req)
+	if err != nil {
+		respondServiceError(c, "Failed to update zone", err)
+		return
+	}
+
+	respondCommitted(c, http.StatusOK, gin.H{
+		"message": "Zone updated successfully",
+		"zoneID":  id,
+	}, result)
+}
+
+func deleteZone(c *gin.Context) {
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	var req DeleteRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+
+	result, err := ledger.DeleteZone(c.Request.Context(), id, req)
+	if err != nil {
+		respondServiceError(c, "Failed to delete zone", err)
+		return
+	}
+
+	respondCommitted(c, http.StatusOK, gin.H{
+		"message": "Zone deleted successfully",
+		"zoneID":  id,
+	}, result)
+}
+
+func evaluateGeofence(c *gin.Context) {
+	var req EvaluateGeofenceRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+
+	evaluation, err := ledger.EvaluateGeofence(req)
+	if err != nil {
+		respondServiceError(c, "Failed to evaluate geofence", err)
+		return
+	}
+
+	respondData(c, http.StatusOK, evaluation)
+}
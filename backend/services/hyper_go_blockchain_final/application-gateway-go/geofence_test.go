@@ -0,0 +1,160 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+func zoneEvent(t *testing.T, name string, doc ZoneDocument) *client.ChaincodeEvent {
+	t.Helper()
+	payload, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &client.ChaincodeEvent{EventName: name, Payload: payload}
+}
+
+func TestGeofenceEvaluate(t *testing.T) {
+	geofence = newGeofenceIndex()
+	defer func() { geofence = nil }()
+	ctx := context.Background()
+
+	// A park with a closed lake in the middle, a night-time trail closure and a nationwide advisory
+	park := `{"type":"Polygon","coordinates":[[[91.80,25.50],[91.90,25.50],[91.90,25.60],[91.80,25.60],[91.80,25.50]],` +
+		`[[91.84,25.54],[91.86,25.54],[91.86,25.56],[91.84,25.56],[91.84,25.54]]]}`
+	trail := `{"type":"MultiPolygon","coordinates":[[[[91.85,25.52],[91.88,25.52],[91.88,25.53],[91.85,25.52]]],` +
+		`[[[91.81,25.58],[91.83,25.58],[91.83,25.59],[91.81,25.59],[91.81,25.58]]]]}`
+	national := `{"type":"Polygon","coordinates":[[[68.0,6.0],[98.0,6.0],[98.0,36.0],[68.0,36.0],[68.0,6.0]]]}`
+	for _, doc := range []ZoneDocument{
+		{ZoneID: "park", Name: "Ward's Lake park", Geometry: park, RiskLevel: "low"},
+		{ZoneID: "trail", Name: "Ridge trail", Geometry: trail, RiskLevel: "restricted", ActiveFrom: "18:00", ActiveTo: "06:00", Timezone: "Asia/Kolkata"},
+		{ZoneID: "national", Name: "Nationwide advisory", Geometry: national, RiskLevel: "medium"},
+	} {
+		geofenceFromEvent(ctx, zoneEvent(t, "CreateZone", doc))
+	}
+	if geofence.tree.size != 3 {
+		t.Fatalf("expected three indexed zones, got %d", geofence.tree.size)
+	}
+
+	night := time.Date(2025, 7, 1, 17, 0, 0, 0, time.UTC) // 22:30 IST
+	day := time.Date(2025, 7, 1, 6, 0, 0, 0, time.UTC)    // 11:30 IST
+	cases := []struct {
+		name      string
+		lat, lng  float64
+		at        time.Time
+		zones     []string
+		active    []bool
+		riskLevel string
+	}{
+		{"trail at night", 25.584, 91.82, night, []string{"national", "park", "trail"}, []bool{true, true, true}, "restricted"},
+		{"trail by day", 25.584, 91.82, day, []string{"national", "park", "trail"}, []bool{true, true, false}, "medium"},
+		{"lake hole", 25.55, 91.85, night, []string{"national"}, []bool{true}, "medium"},
+		{"outside the triangle", 25.529, 91.851, night, []string{"national", "park"}, []bool{true, true}, "medium"},
+		{"elsewhere", 40.0, 80.0, night, []string{}, nil, ""},
+	}
+	for _, tc := range cases {
+		got := geofence.evaluate(tc.lat, tc.lng, tc.at)
+		if len(got.Zones) != len(tc.zones) || got.RiskLevel != tc.riskLevel {
+			t.Fatalf("%s: got %+v, expected zones %v at %q", tc.name, got, tc.zones, tc.riskLevel)
+		}
+		for i, match := range got.Zones {
+			if match.ZoneID != tc.zones[i] || match.Active != tc.active[i] {
+				t.Errorf("%s: zone %d = %+v, expected %s active=%v", tc.name, i, match, tc.zones[i], tc.active[i])
+			}
+		}
+	}
+
+	geofenceFromEvent(ctx, zoneEvent(t, "DeleteZone", ZoneDocument{ZoneID: "trail"}))
+	geofenceFromEvent(ctx, zoneEvent(t, "UpdateZone", ZoneDocument{ZoneID: "park", Name: "Ward's Lake park", Geometry: park, RiskLevel: "high"}))
+	got := geofence.evaluate(25.584, 91.82, night)
+	if len(got.Zones) != 2 || got.RiskLevel != "high" || geofence.tree.size != 2 {
+		t.Errorf("expected the trail removed and the park raised to high, got %+v", got)
+	}
+}
+
+func TestZoneGeometryValidation(t *testing.T) {
+	cases := map[string]string{
+		"valid":        `{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,0]]]}`,
+		"valid circle": `{"type":"Circle","coordinates":[91.88,25.57],"radius":500}`,
+		"wide circle":  `{"type":"Circle","coordinates":[91.88,25.57],"radius":80000}`,
+		"radius":       `{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,0]]],"radius":10}`,
+		"point":        `{"type":"Point","coordinates":[0,0]}`,
+		"open ring":    `{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,1]]]}`,
+		"short ring":   `{"type":"Polygon","coordinates":[[[0,0],[1,0],[0,0]]]}`,
+		"bad lat":      `{"type":"Polygon","coordinates":[[[0,0],[1,95],[1,1],[0,0]]]}`,
+		"empty multi":  `{"type":"MultiPolygon","coordinates":[]}`,
+	}
+	for name, raw := range cases {
+		var geometry GeoJSONGeometry
+		json.Unmarshal([]byte(raw), &geometry)
+		errs := UpdateZoneRequest{Name: "zone", Geometry: &geometry, RiskLevel: "high", Actor: "admin"}.Validate()
+		if strings.HasPrefix(name, "valid") != (len(errs) == 0) {
+			t.Errorf("%s: unexpected validation result %v", name, errs)
+		}
+	}
+
+	hours := UpdateZoneRequest{Name: "zone", RiskLevel: "extreme", Hours: &ZoneHours{Start: "06:00", End: "06:00", Timezone: "Mars/Olympus"}}.Validate()
+	if len(hours) != 3 {
+		t.Errorf("expected risk level, hours and timezone errors, got %v", hours)
+	}
+}
+
+func TestZoneTree(t *testing.T) {
+	// Squares and circles around Shillong, inserted, searched and half removed against a brute force
+	tree := newZoneTree()
+	rng := rand.New(rand.NewSource(7))
+	var zones []*indexedZone
+	for i := range 300 {
+		lng, lat := 91.5+rng.Float64(), 25.2+rng.Float64()
+		geometry := fmt.Sprintf(`{"type":"Circle","coordinates":[%f,%f],"radius":%d}`, lng, lat, 100+rng.Intn(5000))
+		if i%2 == 0 {
+			d := rng.Float64() / 20
+			geometry = fmt.Sprintf(`{"type":"Polygon","coordinates":[[[%f,%f],[%f,%f],[%f,%f],[%f,%f]]]}`, lng, lat, lng+d, lat, lng+d, lat+d, lng, lat)
+		}
+		z, err := newIndexedZone(ZoneDocument{ZoneID: fmt.Sprint(i), Geometry: geometry, RiskLevel: "low"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		zones = append(zones, z)
+		tree.insert(z)
+	}
+
+	check := func(live []*indexedZone) {
+		t.Helper()
+		for range 200 {
+			lng, lat := 91.5+rng.Float64(), 25.2+rng.Float64()
+			found := map[*indexedZone]bool{}
+			tree.search(bounds{lng, lat, lng, lat}, func(z *indexedZone) { found[z] = true })
+			for _, z := range live {
+				if z.bbox.contains(lng, lat) != found[z] {
+					t.Fatalf("search at %f,%f disagrees with the brute force on zone %s", lng, lat, z.zone.ZoneID)
+				}
+			}
+			if len(found) > len(live) {
+				t.Fatalf("search found removed zones")
+			}
+		}
+	}
+	check(zones)
+	for _, z := range zones[:150] {
+		if !tree.remove(z) {
+			t.Fatalf("zone %s not found for removal", z.zone.ZoneID)
+		}
+	}
+	if tree.size != 150 || tree.remove(zones[0]) {
+		t.Fatalf("expected 150 zones left, got %d", tree.size)
+	}
+	check(zones[150:])
+
+	circle := zones[1]
+	if !circle.contains(circle.circle.lng, circle.circle.lat) || circle.contains(circle.circle.lng, circle.circle.lat+circle.circle.radius/100000) {
+		t.Errorf("expected the circle to contain its centre and not a point beyond its radius")
+	}
+}
@@ -0,0 +1,398 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	graphql "github.com/graph-gophers/graphql-go"
+)
+
+const (
+	gqlMaxDepth         = 6
+	gqlMaxLedgerQueries = 100
+)
+
+// gqlSchemaDefinition is the read model exposed at /graphql. Only queries are defined, so
+// mutations fail validation.
+const gqlSchemaDefinition = `
+schema {
+	query: Query
+}
+
+type Query {
+	did(id: ID!): DID
+	incident(id: ID!): Incident
+	evidence(id: ID!): Evidence
+	auditTrail(targetId: ID!): [Audit]
+}
+
+type DID {
+	digitalId: String
+	consentHash: String
+	issuedAt: String
+	expiresAt: String
+	issuer: String
+	txId: String
+	expired: Boolean
+	auditTrail: [Audit]
+}
+
+type Incident {
+	incidentId: String
+	incidentSummaryHash: String
+	createdAt: String
+	reporter: String
+	status: String
+	severity: String
+	category: String
+	subjectId: String
+	geohash: String
+	txId: String
+	evidence: [Evidence]
+	auditTrail: [Audit]
+}
+
+type Evidence {
+	evidenceId: String
+	evidenceHash: String
+	incidentId: String
+	mediaType: String
+	uploadedBy: String
+	createdAt: String
+	cid: String
+	txId: String
+	incident: Incident
+	auditTrail: [Audit]
+}
+
+type Audit {
+	auditHash: String
+	actor: String
+	action: String
+	targetId: String
+	timestamp: String
+	txId: String
+	submitter: String
+	route: String
+	requestId: String
+	status: String
+}
+`
+
+var gqlSchema = graphql.MustParseSchema(gqlSchemaDefinition, &gqlQuery{}, graphql.MaxDepth(gqlMaxDepth))
+
+// gqlQuery resolves the root fields. Field resolvers are matched to methods by name.
+type gqlQuery struct{}
+
+func (gqlQuery) Did(ctx context.Context, args struct{ ID graphql.ID }) (*gqlDID, error) {
+	id := string(args.ID)
+	did, err := gqlLoad(ctx, "did:"+id, func(ctx context.Context) (DIDDocument, error) { return ledger.GetDID(ctx, id) })
+	if err != nil {
+		return nil, err
+	}
+	return &gqlDID{did}, nil
+}
+
+func (gqlQuery) Incident(ctx context.Context, args struct{ ID graphql.ID }) (*gqlIncident, error) {
+	return gqlLoadIncident(ctx, string(args.ID))
+}
+
+func (gqlQuery) Evidence(ctx context.Context, args struct{ ID graphql.ID }) (*gqlEvidence, error) {
+	id := string(args.ID)
+	evidence, err := gqlLoad(ctx, "evidence:"+id, func(ctx context.Context) (EvidenceDocument, error) { return ledger.GetEvidence(ctx, id) })
+	if err != nil {
+		return nil, err
+	}
+	return &gqlEvidence{evidence}, nil
+}
+
+func (gqlQuery) AuditTrail(ctx context.Context, args struct{ TargetID graphql.ID }) (*[]*gqlAudit, error) {
+	return gqlAuditTrail(ctx, string(args.TargetID))
+}
+
+type gqlDID struct{ d DIDDocument }
+
+func (r *gqlDID) DigitalID() *string   { return gqlString(r.d.DigitalID) }
+func (r *gqlDID) ConsentHash() *string { return gqlString(r.d.ConsentHash) }
+func (r *gqlDID) IssuedAt() *string    { return gqlString(r.d.IssuedAt) }
+func (r *gqlDID) ExpiresAt() *string   { return gqlString(r.d.ExpiresAt) }
+func (r *gqlDID) Issuer() *string      { return gqlString(r.d.Issuer) }
+func (r *gqlDID) TxID() *string        { return gqlString(r.d.TxID) }
+
+func (r *gqlDID) Expired() *bool {
+	expiresAt, err := time.Parse(time.RFC3339, r.d.ExpiresAt)
+	if err != nil {
+		return nil
+	}
+	expired := !expiresAt.After(time.Now())
+	return &expired
+}
+
+func (r *gqlDID) AuditTrail(ctx context.Context) (*[]*gqlAudit, error) {
+	return gqlAuditTrail(ctx, r.d.DigitalID)
+}
+
+type gqlIncident struct{ i IncidentDocument }
+
+func (r *gqlIncident) IncidentID() *string          { return gqlString(r.i.IncidentID) }
+func (r *gqlIncident) IncidentSummaryHash() *string { return gqlString(r.i.IncidentSummaryHash) }
+func (r *gqlIncident) CreatedAt() *string           { return gqlString(r.i.CreatedAt) }
+func (r *gqlIncident) Reporter() *string            { return gqlString(r.i.Reporter) }
+func (r *gqlIncident) Status() *string              { return gqlString(r.i.Status) }
+func (r *gqlIncident) Severity() *string            { return gqlString(r.i.Severity) }
+func (r *gqlIncident) Category() *string            { return gqlString(r.i.Category) }
+func (r *gqlIncident) SubjectID() *string           { return gqlString(r.i.SubjectID) }
+func (r *gqlIncident) Geohash() *string             { return gqlString(r.i.Geohash) }
+func (r *gqlIncident) TxID() *string                { return gqlString(r.i.TxID) }
+
+func (r *gqlIncident) Evidence(ctx context.Context) (*[]*gqlEvidence, error) {
+	incidentID := r.i.IncidentID
+	records, err := gqlLoad(ctx, "evidenceByIncident:"+incidentID, func(ctx context.Context) ([]EvidenceDocument, error) {
+		return ledger.ListEvidenceByIncident(ctx, incidentID, EvidenceListRequest{})
+	})
+	if err != nil {
+		return nil, err
+	}
+	list := make([]*gqlEvidence, len(records))
+	for i, record := range records {
+		list[i] = &gqlEvidence{record}
+	}
+	return &list, nil
+}
+
+func (r *gqlIncident) AuditTrail(ctx context.Context) (*[]*gqlAudit, error) {
+	return gqlAuditTrail(ctx, r.i.IncidentID)
+}
+
+type gqlEvidence struct{ e EvidenceDocument }
+
+func (r *gqlEvidence) EvidenceID() *string   { return gqlString(r.e.EvidenceID) }
+func (r *gqlEvidence) EvidenceHash() *string { return gqlString(r.e.EvidenceHash) }
+func (r *gqlEvidence) IncidentID() *string   { return gqlString(r.e.IncidentID) }
+func (r *gqlEvidence) MediaType() *string    { return gqlString(r.e.MediaType) }
+func (r *gqlEvidence) UploadedBy() *string   { return gqlString(r.e.UploadedBy) }
+func (r *gqlEvidence) CreatedAt() *string    { return gqlString(r.e.CreatedAt) }
+func (r *gqlEvidence) CID() *string          { return gqlString(r.e.CID) }
+func (r *gqlEvidence) TxID() *string         { return gqlString(r.e.TxID) }
+
+func (r *gqlEvidence) Incident(ctx context.Context) (*gqlIncident, error) {
+	return gqlLoadIncident(ctx, r.e.IncidentID)
+}
+
+func (r *gqlEvidence) AuditTrail(ctx context.Context) (*[]*gqlAudit, error) {
+	return gqlAuditTrail(ctx, r.e.EvidenceID)
+}
+
+type gqlAudit struct{ a AuditDocument }
+
+func (r *gqlAudit) AuditHash() *string { return gqlString(r.a.AuditHash) }
+func (r *gqlAudit) Actor() *string     { return gqlString(r.a.Actor) }
+func (r *gqlAudit) Action() *string    { return gqlString(r.a.Action) }
+func (r *gqlAudit) TargetID() *string  { return gqlString(r.a.TargetID) }
+func (r *gqlAudit) Timestamp() *string { return gqlString(r.a.Timestamp) }
+func (r *gqlAudit) TxID() *string      { return gqlString(r.a.TxID) }
+func (r *gqlAudit) Submitter() *string { return gqlString(r.a.Submitter) }
+func (r *gqlAudit) Route() *string     { return gqlString(r.a.Route) }
+func (r *gqlAudit) RequestID() *string { return gqlString(r.a.RequestID) }
+func (r *gqlAudit) Status() *string    { return gqlString(r.a.Status) }
+
+func gqlLoadIncident(ctx context.Context, id string) (*gqlIncident, error) {
+	incident, err := gqlLoad(ctx, "incident:"+id, func(ctx context.Context) (IncidentDocument, error) { return ledger.GetIncident(ctx, id) })
+	if err != nil {
+		return nil, err
+	}
+	return &gqlIncident{incident}, nil
+}
+
+func gqlAuditTrail(ctx context.Context, targetID string) (*[]*gqlAudit, error) {
+	list := []*gqlAudit{}
+	if targetID == "" {
+		return &list, nil
+	}
+	audits, err := gqlLoad(ctx, "audits:"+targetID, func(ctx context.Context) ([]AuditDocument, error) {
+		return ledger.ListAuditsByTarget(ctx, targetID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, audit := range audits {
+		list = append(list, &gqlAudit{audit})
+	}
+	return &list, nil
+}
+
+// gqlString maps empty document properties to null
+func gqlString(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return &value
+}
+
+// gqlLoader memoises ledger reads for the duration of one query, so an incident referenced by many
+// evidence records is only read once, and caps how many reads a single query may trigger. Sibling
+// fields resolve concurrently, so a read in flight is waited for rather than repeated.
+type gqlLoader struct {
+	mu     sync.Mutex
+	loaded map[string]*gqlLoadResult
+}
+
+type gqlLoadResult struct {
+	done  chan struct{}
+	value interface{}
+	err   error
+}
+
+type gqlLoaderContextKey struct{}
+
+func gqlLoad[T any](ctx context.Context, key string, load func(ctx context.Context) (T, error)) (T, error) {
+	var zero T
+	loader := ctx.Value(gqlLoaderContextKey{}).(*gqlLoader)
+	loader.mu.Lock()
+	result, ok := loader.loaded[key]
+	if !ok {
+		if len(loader.loaded) >= gqlMaxLedgerQueries {
+			loader.mu.Unlock()
+			return zero, gqlFieldError(ctx, fmt.Errorf("query exceeds the limit of %d ledger reads", gqlMaxLedgerQueries))
+		}
+		result = &gqlLoadResult{done: make(chan struct{})}
+		loader.loaded[key] = result
+	}
+	loader.mu.Unlock()
+
+	if ok {
+		<-result.done
+	} else {
+		value, err := load(ctx)
+		if err != nil {
+			result.err = gqlFieldError(ctx, err)
+		} else {
+			result.value = value
+		}
+		close(result.done)
+	}
+	if result.err != nil {
+		return zero, result.err
+	}
+	return result.value.(T), nil
+}
+
+// GraphQLRequest is the body of a GraphQL-over-HTTP request
+type GraphQLRequest struct {
+	Query         string                 `json:"query" form:"query"`
+	OperationName string                 `json:"operationName" form:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// GraphQLError is an entry in the errors list of a GraphQL response
+type GraphQLError struct {
+	Message    string                 `json:"message"`
+	Path       []interface{}          `json:"path,omitempty"`
+	Extensions map[string]interface{} `json:"extensions,omitempty"`
+}
+
+// GraphQLResponse is the standard GraphQL result shape rather than the REST envelope, so stock
+// GraphQL clients work unchanged
+type GraphQLResponse struct {
+	Data   interface{}    `json:"data"`
+	Errors []GraphQLError `json:"errors,omitempty"`
+}
+
+// graphqlHandler serves queries sent as POST JSON bodies or GET query parameters
+func graphqlHandler(c *gin.Context) {
+	var req GraphQLRequest
+	if c.Request.Method == http.MethodGet {
+		req.Query = c.Query("query")
+		req.OperationName = c.Query("operationName")
+		if raw := c.Query("variables"); raw != "" {
+			if err := json.Unmarshal([]byte(raw), &req.Variables); err != nil {
+				c.JSON(http.StatusBadRequest, GraphQLResponse{Errors: []GraphQLError{{Message: "variables must be a JSON object"}}})
+				return
+			}
+		}
+	} else if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, GraphQLResponse{Errors: []GraphQLError{{Message: "request body must be a JSON object with a query"}}})
+		return
+	}
+
+	status, response := executeGraphQL(c.Request.Context(), req)
+	c.JSON(status, response)
+}
+
+// executeGraphQL parses, validates and runs a query, returning 400 for documents that cannot run at all
+func executeGraphQL(ctx context.Context, req GraphQLRequest) (int, GraphQLResponse) {
+	if req.Query == "" {
+		return http.StatusBadRequest, GraphQLResponse{Errors: []GraphQLError{{
+			Message:    "query is required",
+			Extensions: map[string]interface{}{"code": "GRAPHQL_VALIDATION_FAILED"},
+		}}}
+	}
+
+	ctx = context.WithValue(ctx, gqlLoaderContextKey{}, &gqlLoader{loaded: map[string]*gqlLoadResult{}})
+	result := gqlSchema.Exec(ctx, req.Query, req.OperationName, req.Variables)
+	// A document that fails to parse or validate produces no data, and variables of the wrong type
+	// fail before any field resolves. Field errors always carry a path.
+	invalid := result.Data == nil
+	for _, err := range result.Errors {
+		invalid = invalid || len(err.Path) == 0
+	}
+	response := GraphQLResponse{}
+	for _, err := range result.Errors {
+		gqlErr := GraphQLError{Message: err.Message, Path: err.Path, Extensions: err.Extensions}
+		if invalid {
+			gqlErr.Extensions = map[string]interface{}{"code": "GRAPHQL_VALIDATION_FAILED"}
+		}
+		response.Errors = append(response.Errors, gqlErr)
+	}
+	if invalid {
+		return http.StatusBadRequest, response
+	}
+	response.Data = result.Data
+	return http.StatusOK, response
+}
+
+// gqlError is a resolver error carrying the REST error code in its extensions
+type gqlError struct {
+	message    string
+	extensions map[string]interface{}
+}
+
+func (e *gqlError) Error() string { return e.message }
+
+func (e *gqlError) Extensions() map[string]interface{} { return e.extensions }
+
+// gqlFieldError translates a failed ledger read as the REST API would
+func gqlFieldError(ctx context.Context, err error) error {
+	gqlErr := &gqlError{message: err.Error()}
+
+	var validationErrs ValidationErrors
+	var malformed *malformedDocumentError
+	switch {
+	case errors.As(err, &validationErrs):
+		gqlErr.extensions = map[string]interface{}{"code": errCodeValidation, "fields": validationErrs}
+	case errors.As(err, &malformed):
+		gqlErr.extensions = map[string]interface{}{"code": errCodeInternal}
+	default:
+		translated := translateFabricError(err)
+		gqlErr.message = translated.Message
+		gqlErr.extensions = map[string]interface{}{"code": translated.Code}
+		if translated.Code == errCodeInternal && !isPeerFailure(err) && !errors.Is(err, errCircuitOpen) {
+			// Resolver limits and other local failures are not Fabric errors
+			gqlErr.message = err.Error()
+		}
+		logWithContext(ctx, "GraphQL field failed: %v", err)
+	}
+	return gqlErr
+}
@@ -0,0 +1,74 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestGraphQLLoader(t *testing.T) {
+	ctx := context.WithValue(context.Background(), gqlLoaderContextKey{}, &gqlLoader{loaded: map[string]*gqlLoadResult{}})
+	calls := 0
+	load := func(context.Context) (IncidentDocument, error) {
+		calls++
+		return IncidentDocument{IncidentID: "INC-1"}, nil
+	}
+	for range 3 {
+		if incident, err := gqlLoad(ctx, "incident:INC-1", load); err != nil || incident.IncidentID != "INC-1" {
+			t.Fatalf("got %+v, %v", incident, err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("expected one ledger read for a repeated key, got %d", calls)
+	}
+
+	for i := 1; i < gqlMaxLedgerQueries; i++ {
+		gqlLoad(ctx, "incident:"+strconv.Itoa(i), load)
+	}
+	_, err := gqlLoad(ctx, "incident:one-too-many", load)
+	var gqlErr *gqlError
+	if !errors.As(err, &gqlErr) || gqlErr.Extensions()["code"] != errCodeInternal || !strings.Contains(err.Error(), "ledger reads") {
+		t.Errorf("expected the read limit reported, got %v", err)
+	}
+}
+
+func TestExecuteGraphQLValidation(t *testing.T) {
+	tests := map[string]GraphQLRequest{
+		"unknown field":      {Query: `{ incident(id: "INC-1") { secret } }`},
+		"missing argument":   {Query: `{ incident { incidentId } }`},
+		"unknown argument":   {Query: `{ did(id: "did:sih:1", at: "now") { issuer } }`},
+		"scalar selection":   {Query: `{ did(id: "did:sih:1") { issuer { name } } }`},
+		"missing selection":  {Query: `{ did(id: "did:sih:1") }`},
+		"missing variable":   {Query: `query ($id: ID!) { did(id: $id) { issuer } }`},
+		"wrong variable":     {Query: `query ($id: ID!) { did(id: $id) { issuer } }`, Variables: map[string]interface{}{"id": true}},
+		"unknown fragment":   {Query: `{ did(id: "did:sih:1") { ...nope } }`},
+		"mutation":           {Query: `mutation { did(id: "did:sih:1") { issuer } }`},
+		"too deep":           {Query: `{ evidence(id: "E1") { incident { evidence { incident { evidence { incident { incidentId } } } } } } }`},
+		"ambiguous document": {Query: `query A { did(id: "x") { issuer } } query B { did(id: "y") { issuer } }`},
+	}
+
+	for name, req := range tests {
+		status, response := executeGraphQL(context.Background(), req)
+		if status != http.StatusBadRequest || len(response.Errors) == 0 || response.Data != nil {
+			t.Errorf("%s: status %d, errors %v, want a 400 error", name, status, response.Errors)
+		}
+	}
+}
+
+func TestExecuteGraphQLTypename(t *testing.T) {
+	status, response := executeGraphQL(context.Background(), GraphQLRequest{Query: `{ kind: __typename, __typename @skip(if: true) }`})
+	if status != http.StatusOK || len(response.Errors) != 0 {
+		t.Fatalf("status %d, errors %v", status, response.Errors)
+	}
+	body, err := json.Marshal(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(body), `"data":{"kind":"Query"}`) {
+		t.Errorf("body = %s", body)
+	}
+}
@@ -0,0 +1,410 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	sihv1 "assetTransfer/proto/sih/v1"
+
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+)
+
+const grpcErrorDomain = "sih.v1"
+
+// runGRPCServer serves LedgerService and LocationService on GRPC_LISTEN_ADDR, sharing the REST
+// server's TLS material
+func runGRPCServer(ctx context.Context, reloader *tlsReloader) error {
+	addr := getEnv("GRPC_LISTEN_ADDR", ":9090")
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	opts := []grpc.ServerOption{
+		grpc.StatsHandler(otelgrpc.NewServerHandler()),
+		grpc.ChainUnaryInterceptor(grpcLoggingInterceptor, grpcTargetInterceptor, grpcSignerInterceptor, grpcCanaryInterceptor, grpcAPIAuditInterceptor, grpcAuthorizeInterceptor),
+		grpc.ChainStreamInterceptor(grpcLoggingStreamInterceptor, grpcTargetStreamInterceptor, grpcSignerStreamInterceptor, grpcCanaryStreamInterceptor, grpcAuthorizeStreamInterceptor),
+	}
+	if reloader != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(reloader.tlsConfig())))
+	}
+	server := grpc.NewServer(opts...)
+	sihv1.RegisterLedgerServiceServer(server, &grpcLedgerServer{})
+	sihv1.RegisterLocationServiceServer(server, newGRPCLocationServer())
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	reflection.Register(server)
+
+	go func() {
+		<-ctx.Done()
+		server.GracefulStop()
+	}()
+
+	log.Printf("🛰️  SIH gRPC server starting on %s", addr)
+	return server.Serve(listener)
+}
+
+// grpcLoggingInterceptor logs each RPC against the server span otelgrpc opened for it, matching the
+// REST request log
+func grpcLoggingInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	logWithContext(ctx, "gRPC %s %s %s", info.FullMethod, status.Code(err), time.Since(start))
+	return resp, err
+}
+
+// grpcLoggingStreamInterceptor logs a whole stream once it ends
+func grpcLoggingStreamInterceptor(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	err := handler(srv, stream)
+	logWithContext(stream.Context(), "gRPC stream %s %s %s", info.FullMethod, status.Code(err), time.Since(start))
+	return err
+}
+
+// contextStream replaces the context of a stream, as the unary interceptors do by passing theirs on
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s contextStream) Context() context.Context {
+	return s.ctx
+}
+
+// grpcError converts a service layer error into a gRPC status carrying the same error code as REST
+func grpcError(action string, err error) error {
+	var validationErrs ValidationErrors
+	if errors.As(err, &validationErrs) {
+		st := status.New(codes.InvalidArgument, "request validation failed")
+		violations := make([]*errdetails.BadRequest_FieldViolation, 0, len(validationErrs))
+		for _, fieldErr := range validationErrs {
+			violations = append(violations, &errdetails.BadRequest_FieldViolation{Field: fieldErr.Field, Description: fieldErr.Message})
+		}
+		if detailed, detailErr := st.WithDetails(&errdetails.BadRequest{FieldViolations: violations}); detailErr == nil {
+			st = detailed
+		}
+		return st.Err()
+	}
+
+	var malformed *malformedDocumentError
+	if errors.As(err, &malformed) {
+		return status.Error(codes.Internal, malformed.Error())
+	}
+
+	translated := translateFabricError(err)
+	st := status.New(grpcCodeForHTTP(translated.Status), action+": "+translated.Message)
+	info := &errdetails.ErrorInfo{Reason: translated.Code, Domain: grpcErrorDomain}
+	if translated.TxID != "" {
+		info.Metadata = map[string]string{"tx_id": translated.TxID}
+	}
+	if detailed, detailErr := st.WithDetails(info); detailErr == nil {
+		st = detailed
+	}
+	return st.Err()
+}
+
+func grpcCodeForHTTP(httpStatus int) codes.Code {
+	switch httpStatus {
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.Aborted
+	case http.StatusUnprocessableEntity:
+		return codes.FailedPrecondition
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusServiceUnavailable:
+		return codes.Unavailable
+	}
+	return codes.Internal
+}
+
+func receipt(result *TransactionResult) *sihv1.TransactionReceipt {
+	return &sihv1.TransactionReceipt{TxId: result.TxID, BlockNumber: result.BlockNumber}
+}
+
+// grpcLedgerServer adapts the ledger service to the generated LedgerService interface
+type grpcLedgerServer struct {
+	sihv1.UnimplementedLedgerServiceServer
+}
+
+func (s *grpcLedgerServer) CreateDID(ctx context.Context, in *sihv1.CreateDIDRequest) (*sihv1.TransactionReceipt, error) {
+	result, err := ledger.CreateDID(ctx, CreateDIDRequest{
+		DigitalID:   in.GetDigitalId(),
+		ConsentHash: in.GetConsentHash(),
+		ExpiresAt:   in.GetExpiresAt(),
+		Issuer:      in.GetIssuer(),
+	})
+	if err != nil {
+		return nil, grpcError("Failed to create DID", err)
+	}
+	return receipt(result), nil
+}
+
+func (s *grpcLedgerServer) GetDID(ctx context.Context, in *sihv1.GetDocumentRequest) (*sihv1.DIDDocument, error) {
+	doc, err := ledger.GetDID(ctx, in.GetId())
+	if err != nil {
+		return nil, grpcError("Failed to read DID", err)
+	}
+	return &sihv1.DIDDocument{
+		DocType:     doc.DocType,
+		DigitalId:   doc.DigitalID,
+		ConsentHash: doc.ConsentHash,
+		IssuedAt:    doc.IssuedAt,
+		ExpiresAt:   doc.ExpiresAt,
+		Issuer:      doc.Issuer,
+		TxId:        doc.TxID,
+	}, nil
+}
+
+func (s *grpcLedgerServer) VerifyDID(ctx context.Context, in *sihv1.GetDocumentRequest) (*sihv1.DIDVerification, error) {
+	verdict, err := ledger.VerifyDID(ctx, in.GetId())
+	if err != nil {
+		return nil, grpcError("Failed to verify DID", err)
+	}
+	return &sihv1.DIDVerification{
+		DigitalId:  verdict.DigitalID,
+		Valid:      verdict.Valid,
+		Exists:     verdict.Exists,
+		Expired:    verdict.Expired,
+		Revoked:    verdict.Revoked,
+		Reason:     verdict.Reason,
+		Issuer:     verdict.Issuer,
+		IssuedAt:   verdict.IssuedAt,
+		ExpiresAt:  verdict.ExpiresAt,
+		VerifiedAt: verdict.VerifiedAt,
+		TxId:       verdict.TxID,
+	}, nil
+}
+
+func (s *grpcLedgerServer) UpdateDID(ctx context.Context, in *sihv1.UpdateDIDRequest) (*sihv1.TransactionReceipt, error) {
+	result, err := ledger.UpdateDID(ctx, in.GetDigitalId(), UpdateDIDRequest{
+		ConsentHash: in.GetConsentHash(),
+		ExpiresAt:   in.GetExpiresAt(),
+		Updater:     in.GetUpdater(),
+	})
+	if err != nil {
+		return nil, grpcError("Failed to update DID", err)
+	}
+	return receipt(result), nil
+}
+
+func (s *grpcLedgerServer) DeleteDID(ctx context.Context, in *sihv1.DeleteDocumentRequest) (*sihv1.TransactionReceipt, error) {
+	result, err := ledger.DeleteDID(ctx, in.GetId(), DeleteRequest{Actor: in.GetActor()})
+	if err != nil {
+		return nil, grpcError("Failed to delete DID", err)
+	}
+	return receipt(result), nil
+}
+
+func (s *grpcLedgerServer) CreateIncident(ctx context.Context, in *sihv1.CreateIncidentRequest) (*sihv1.TransactionReceipt, error) {
+	result, err := ledger.CreateIncident(ctx, CreateIncidentRequest{
+		IncidentID:          in.GetIncidentId(),
+		IncidentSummaryHash: in.GetIncidentSummaryHash(),
+		Reporter:            in.GetReporter(),
+		Severity:            in.GetSeverity(),
+	})
+	if err != nil {
+		return nil, grpcError("Failed to create incident", err)
+	}
+	return receipt(result), nil
+}
+
+func (s *grpcLedgerServer) GetIncident(ctx context.Context, in *sihv1.GetDocumentRequest) (*sihv1.IncidentDocument, error) {
+	doc, err := ledger.GetIncident(ctx, in.GetId())
+	if err != nil {
+		return nil, grpcError("Failed to read incident", err)
+	}
+	return incidentMessage(doc), nil
+}
+
+func (s *grpcLedgerServer) SearchIncidents(ctx context.Context, in *sihv1.SearchIncidentsRequest) (*sihv1.IncidentPage, error) {
+	page, err := ledger.SearchIncidents(ctx, IncidentSearchRequest{
+		From:     in.GetFrom(),
+		To:       in.GetTo(),
+		Status:   in.GetStatus(),
+		Severity: in.GetSeverity(),
+		Reporter: in.GetReporter(),
+		PageSize: int(in.GetPageSize()),
+		Bookmark: in.GetBookmark(),
+	})
+	if err != nil {
+		return nil, grpcError("Failed to search incidents", err)
+	}
+	response := &sihv1.IncidentPage{Incidents: make([]*sihv1.IncidentDocument, 0, len(page.Incidents)), Bookmark: page.Bookmark}
+	for _, doc := range page.Incidents {
+		response.Incidents = append(response.Incidents, incidentMessage(doc))
+	}
+	return response, nil
+}
+
+func incidentMessage(doc IncidentDocument) *sihv1.IncidentDocument {
+	return &sihv1.IncidentDocument{
+		DocType:             doc.DocType,
+		IncidentId:          doc.IncidentID,
+		IncidentSummaryHash: doc.IncidentSummaryHash,
+		CreatedAt:           doc.CreatedAt,
+		Reporter:            doc.Reporter,
+		Status:              doc.Status,
+		Severity:            doc.Severity,
+		TxId:                doc.TxID,
+	}
+}
+
+func (s *grpcLedgerServer) UpdateIncident(ctx context.Context, in *sihv1.UpdateIncidentRequest) (*sihv1.TransactionReceipt, error) {
+	result, err := ledger.UpdateIncident(ctx, in.GetIncidentId(), UpdateIncidentRequest{
+		IncidentSummaryHash: in.GetIncidentSummaryHash(),
+		Updater:             in.GetUpdater(),
+	})
+	if err != nil {
+		return nil, grpcError("Failed to update incident", err)
+	}
+	return receipt(result), nil
+}
+
+func (s *grpcLedgerServer) DeleteIncident(ctx context.Context, in *sihv1.DeleteDocumentRequest) (*sihv1.TransactionReceipt, error) {
+	result, err := ledger.DeleteIncident(ctx, in.GetId(), DeleteRequest{Actor: in.GetActor()})
+	if err != nil {
+		return nil, grpcError("Failed to delete incident", err)
+	}
+	return receipt(result), nil
+}
+
+func (s *grpcLedgerServer) CreateEvidence(ctx context.Context, in *sihv1.CreateEvidenceRequest) (*sihv1.TransactionReceipt, error) {
+	result, err := ledger.CreateEvidence(ctx, CreateEvidenceRequest{
+		EvidenceID:   in.GetEvidenceId(),
+		EvidenceHash: in.GetEvidenceHash(),
+		IncidentID:   in.GetIncidentId(),
+		MediaType:    in.GetMediaType(),
+		UploadedBy:   in.GetUploadedBy(),
+	})
+	if err != nil {
+		return nil, grpcError("Failed to create evidence", err)
+	}
+	return receipt(result), nil
+}
+
+func evidenceMessage(doc EvidenceDocument) *sihv1.EvidenceDocument {
+	return &sihv1.EvidenceDocument{
+		DocType:      doc.DocType,
+		EvidenceId:   doc.EvidenceID,
+		EvidenceHash: doc.EvidenceHash,
+		IncidentId:   doc.IncidentID,
+		MediaType:    doc.MediaType,
+		UploadedBy:   doc.UploadedBy,
+		CreatedAt:    doc.CreatedAt,
+		Cid:          doc.CID,
+		TxId:         doc.TxID,
+	}
+}
+
+func (s *grpcLedgerServer) GetEvidence(ctx context.Context, in *sihv1.GetDocumentRequest) (*sihv1.EvidenceDocument, error) {
+	doc, err := ledger.GetEvidence(ctx, in.GetId())
+	if err != nil {
+		return nil, grpcError("Failed to read evidence", err)
+	}
+	return evidenceMessage(doc), nil
+}
+
+func (s *grpcLedgerServer) UpdateEvidence(ctx context.Context, in *sihv1.UpdateEvidenceRequest) (*sihv1.TransactionReceipt, error) {
+	result, err := ledger.UpdateEvidence(ctx, in.GetEvidenceId(), UpdateEvidenceRequest{
+		EvidenceHash: in.GetEvidenceHash(),
+		MediaType:    in.GetMediaType(),
+		Updater:      in.GetUpdater(),
+	})
+	if err != nil {
+		return nil, grpcError("Failed to update evidence", err)
+	}
+	return receipt(result), nil
+}
+
+func (s *grpcLedgerServer) DeleteEvidence(ctx context.Context, in *sihv1.DeleteDocumentRequest) (*sihv1.TransactionReceipt, error) {
+	result, err := ledger.DeleteEvidence(ctx, in.GetId(), DeleteRequest{Actor: in.GetActor()})
+	if err != nil {
+		return nil, grpcError("Failed to delete evidence", err)
+	}
+	return receipt(result), nil
+}
+
+func (s *grpcLedgerServer) ListEvidenceByIncident(ctx context.Context, in *sihv1.ListEvidenceByIncidentRequest) (*sihv1.EvidenceList, error) {
+	docs, err := ledger.ListEvidenceByIncident(ctx, in.GetIncidentId(), EvidenceListRequest{})
+	if err != nil {
+		return nil, grpcError("Failed to get evidence by incident", err)
+	}
+	list := &sihv1.EvidenceList{Evidence: make([]*sihv1.EvidenceDocument, 0, len(docs))}
+	for _, doc := range docs {
+		list.Evidence = append(list.Evidence, evidenceMessage(doc))
+	}
+	return list, nil
+}
+
+func (s *grpcLedgerServer) ListAuditsByTarget(ctx context.Context, in *sihv1.ListAuditsByTargetRequest) (*sihv1.AuditList, error) {
+	docs, err := ledger.ListAuditsByTarget(ctx, in.GetTargetId())
+	if err != nil {
+		return nil, grpcError("Failed to get audit logs", err)
+	}
+	list := &sihv1.AuditList{Audits: make([]*sihv1.AuditDocument, 0, len(docs))}
+	for _, doc := range docs {
+		list.Audits = append(list.Audits, auditMessage(doc))
+	}
+	return list, nil
+}
+
+func (s *grpcLedgerServer) SearchAudits(ctx context.Context, in *sihv1.SearchAuditsRequest) (*sihv1.AuditPage, error) {
+	page, err := ledger.SearchAudits(ctx, AuditSearchRequest{
+		Actor:    in.GetActor(),
+		Action:   in.GetAction(),
+		From:     in.GetFrom(),
+		To:       in.GetTo(),
+		PageSize: int(in.GetPageSize()),
+		Bookmark: in.GetBookmark(),
+	})
+	if err != nil {
+		return nil, grpcError("Failed to search audit logs", err)
+	}
+	response := &sihv1.AuditPage{Audits: make([]*sihv1.AuditDocument, 0, len(page.Audits)), Bookmark: page.Bookmark}
+	for _, doc := range page.Audits {
+		response.Audits = append(response.Audits, auditMessage(doc))
+	}
+	return response, nil
+}
+
+func auditMessage(doc AuditDocument) *sihv1.AuditDocument {
+	return &sihv1.AuditDocument{
+		DocType:   doc.DocType,
+		AuditHash: doc.AuditHash,
+		Actor:     doc.Actor,
+		Action:    doc.Action,
+		TargetId:  doc.TargetID,
+		Timestamp: doc.Timestamp,
+		TxId:      doc.TxID,
+		Submitter: doc.Submitter,
+	}
+}
+
+// grpcEnabled reports whether the gRPC listener should be started
+func grpcEnabled() bool {
+	return !strings.EqualFold(getEnv("GRPC_LISTEN_ADDR", ":9090"), "off")
+}
@@ -0,0 +1,72 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	sihv1 "assetTransfer/proto/sih/v1"
+	"assetTransfer/sihclient"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// The SDK's gRPC credentials plug into grpc.WithPerRPCCredentials
+var _ credentials.PerRPCCredentials = sihclient.RPCCredentials{}
+
+func TestGRPCValidationErrors(t *testing.T) {
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	sihv1.RegisterLedgerServiceServer(server, &grpcLedgerServer{})
+	go server.Serve(listener)
+	defer server.Stop()
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := sihv1.NewLedgerServiceClient(conn)
+
+	_, err = client.CreateDID(context.Background(), &sihv1.CreateDIDRequest{DigitalId: "not-a-did"})
+	st := status.Convert(err)
+	if st.Code() != codes.InvalidArgument {
+		t.Fatalf("code = %s, want InvalidArgument", st.Code())
+	}
+
+	fields := map[string]bool{}
+	for _, detail := range st.Details() {
+		if badRequest, ok := detail.(*errdetails.BadRequest); ok {
+			for _, violation := range badRequest.GetFieldViolations() {
+				fields[violation.GetField()] = true
+			}
+		}
+	}
+	for _, field := range []string{"digitalID", "consentHash", "expiresAt", "issuer"} {
+		if !fields[field] {
+			t.Errorf("missing field violation for %s", field)
+		}
+	}
+
+	_, err = client.GetEvidence(context.Background(), &sihv1.GetDocumentRequest{Id: "bad id"})
+	if code := status.Code(err); code != codes.InvalidArgument {
+		t.Errorf("GetEvidence code = %s, want InvalidArgument", code)
+	}
+}
+
+func TestVerifyDIDRejectsNonDID(t *testing.T) {
+	_, err := ledger.VerifyDID(context.Background(), "tourist-001")
+	var errs ValidationErrors
+	if !errors.As(err, &errs) || errs[0].Field != "id" {
+		t.Fatalf("err = %v, want a validation error on id", err)
+	}
+}
@@ -0,0 +1,160 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	apiVersion         = "1.0.0"
+	qsccName           = "qscc"
+	healthCheckTimeout = 3 * time.Second
+)
+
+const (
+	statusOK       = "OK"
+	statusDegraded = "DEGRADED"
+	statusDown     = "DOWN"
+)
+
+var (
+	lastEventBlock atomic.Uint64
+	lastEventAt    atomic.Int64
+)
+
+// DependencyStatus reports the health of a single downstream dependency
+type DependencyStatus struct {
+	Status    string `json:"status"`
+	LatencyMS int64  `json:"latency_ms"`
+	Detail    string `json:"detail,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// recordEventBlock remembers the block number of the most recent chaincode event
+func recordEventBlock(blockNumber uint64) {
+	lastEventBlock.Store(blockNumber)
+	lastEventAt.Store(time.Now().Unix())
+}
+
+// checkGatewayConnection reports the state of the gRPC connection to the Gateway peer
+func checkGatewayConnection() DependencyStatus {
+	if clientConnection == nil {
+		return DependencyStatus{Status: statusDown, Error: "gateway connection not initialised"}
+	}
+
+	state := clientConnection.GetState()
+	switch state {
+	case connectivity.Ready, connectivity.Idle:
+		return DependencyStatus{Status: statusOK, Detail: state.String()}
+	case connectivity.Connecting:
+		return DependencyStatus{Status: statusDegraded, Detail: state.String()}
+	default:
+		// Nudge an idle or failed connection so the next probe has a chance to succeed
+		clientConnection.Connect()
+		return DependencyStatus{Status: statusDown, Detail: state.String(), Error: "gateway connection unavailable"}
+	}
+}
+
+// checkLedger evaluates GetChainInfo on the system chaincode to confirm the peer is serving the channel
+func checkLedger(ctx context.Context) (DependencyStatus, uint64) {
+	if defaultTarget == nil {
+		return DependencyStatus{Status: statusDown, Error: "network not initialised"}, 0
+	}
+
+	start := time.Now()
+	height, err := channelHeight(ctx, defaultTarget)
+	latency := time.Since(start).Milliseconds()
+	if err != nil {
+		return DependencyStatus{Status: statusDown, LatencyMS: latency, Error: err.Error()}, 0
+	}
+
+	return DependencyStatus{
+		Status:    statusOK,
+		LatencyMS: latency,
+		Detail:    fmt.Sprintf("channel %s height %d", defaultTarget.Channel, height),
+	}, height
+}
+
+// channelHeight returns the number of blocks on the target's channel
+func channelHeight(ctx context.Context, target *fabricTarget) (uint64, error) {
+	result, err := target.network.GetContract(qsccName).EvaluateWithContext(ctx, "GetChainInfo", client.WithArguments(target.Channel))
+	if err != nil {
+		return 0, fmt.Errorf("failed to query channel info: %w", err)
+	}
+	var info common.BlockchainInfo
+	if err := proto.Unmarshal(result, &info); err != nil {
+		return 0, fmt.Errorf("failed to parse channel info: %w", err)
+	}
+	return info.GetHeight(), nil
+}
+
+// healthCheck verifies the gateway connection and ledger reachability, returning 503 when Fabric is unavailable
+func healthCheck(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), healthCheckTimeout)
+	defer cancel()
+
+	gatewayStatus := checkGatewayConnection()
+	ledgerStatus, height := checkLedger(ctx)
+	cacheStatus := checkDocumentCache(ctx)
+	indexStatus := checkOffchainIndex(ctx, height)
+
+	dependencies := gin.H{
+		"gateway": gatewayStatus,
+		"ledger":  ledgerStatus,
+		"cache":   cacheStatus,
+		"index":   indexStatus,
+	}
+
+	overall := statusOK
+	httpStatus := http.StatusOK
+	for _, dep := range []DependencyStatus{gatewayStatus, ledgerStatus, cacheStatus, indexStatus} {
+		if dep.Status == statusDown {
+			overall = statusDown
+			httpStatus = http.StatusServiceUnavailable
+			break
+		}
+		if dep.Status == statusDegraded {
+			overall = statusDegraded
+		}
+	}
+
+	response := gin.H{
+		"status":        overall,
+		"message":       "SIH Chaincode API is running",
+		"timestamp":     time.Now().Format(time.RFC3339),
+		"version":       apiVersion,
+		"ledger_height": height,
+		"dependencies":  dependencies,
+		"cache":         documentCacheStats(),
+		"circuit":       fabricBreaker.currentState(),
+	}
+	if defaultTarget != nil {
+		response["channel"] = defaultTarget.Channel
+		response["chaincode"] = defaultTarget.Chaincode
+		response["targets"] = sortedTargets()
+	}
+	if callerWallet != nil {
+		response["wallet_identities"] = callerWallet.labels()
+	}
+	if at := lastEventAt.Load(); at > 0 {
+		response["last_event_block"] = lastEventBlock.Load()
+		response["last_event_at"] = time.Unix(at, 0).UTC().Format(time.RFC3339)
+	}
+
+	c.JSON(httpStatus, response)
+}
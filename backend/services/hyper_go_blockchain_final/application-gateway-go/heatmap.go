@@ -0,0 +1,218 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// ledgerGeohashPrecision is the geohash length recorded on the ledger for incidents and SOS
+	// alerts, a cell of about 1.2 km by 0.6 km
+	ledgerGeohashPrecision  = 6
+	defaultHeatmapPrecision = 5
+	maxHeatmapBuckets       = 5000
+
+	errCodeIndexDisabled = "INDEX_DISABLED"
+)
+
+const geohashAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// encodeGeohash returns the geohash of a point with the given number of characters
+func encodeGeohash(lat, lng float64, precision int) string {
+	latRange, lngRange := [2]float64{-90, 90}, [2]float64{-180, 180}
+	var hash strings.Builder
+	bit, ch, even := 0, 0, true
+	for hash.Len() < precision {
+		r, value := &latRange, lat
+		if even {
+			r, value = &lngRange, lng
+		}
+		mid := (r[0] + r[1]) / 2
+		ch <<= 1
+		if value >= mid {
+			ch |= 1
+			r[0] = mid
+		} else {
+			r[1] = mid
+		}
+		even = !even
+		if bit++; bit == 5 {
+			hash.WriteByte(geohashAlphabet[ch])
+			bit, ch = 0, 0
+		}
+	}
+	return hash.String()
+}
+
+// geohashBounds returns the cell a geohash covers, or false for an invalid geohash
+func geohashBounds(hash string) (bounds, bool) {
+	latRange, lngRange := [2]float64{-90, 90}, [2]float64{-180, 180}
+	even := true
+	for i := 0; i < len(hash); i++ {
+		ch := strings.IndexByte(geohashAlphabet, hash[i])
+		if ch < 0 {
+			return bounds{}, false
+		}
+		for mask := 16; mask > 0; mask >>= 1 {
+			r := &latRange
+			if even {
+				r = &lngRange
+			}
+			mid := (r[0] + r[1]) / 2
+			if ch&mask != 0 {
+				r[0] = mid
+			} else {
+				r[1] = mid
+			}
+			even = !even
+		}
+	}
+	return bounds{minLng: lngRange[0], minLat: latRange[0], maxLng: lngRange[1], maxLat: latRange[1]}, hash != ""
+}
+
+// geohashCenter returns the center of a geohash cell, or nils for an empty or invalid geohash, so
+// the index stores NULL coordinates
+func geohashCenter(hash string) (interface{}, interface{}) {
+	b, ok := geohashBounds(hash)
+	if !ok {
+		return nil, nil
+	}
+	return (b.minLat + b.maxLat) / 2, (b.minLng + b.maxLng) / 2
+}
+
+// HeatmapRequest selects incidents and SOS alerts by area and time
+type HeatmapRequest struct {
+	// BBox is minLongitude,minLatitude,maxLongitude,maxLatitude, as in GeoJSON
+	BBox      string `form:"bbox"`
+	From      string `form:"from"`
+	To        string `form:"to"`
+	Precision int    `form:"precision"`
+
+	box bounds
+}
+
+func (r *HeatmapRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	if r.BBox != "" {
+		parts := strings.Split(r.BBox, ",")
+		var values [4]float64
+		ok := len(parts) == 4
+		for i := 0; ok && i < 4; i++ {
+			var err error
+			values[i], err = strconv.ParseFloat(strings.TrimSpace(parts[i]), 64)
+			ok = err == nil
+		}
+		r.box = bounds{minLng: values[0], minLat: values[1], maxLng: values[2], maxLat: values[3]}
+		switch {
+		case !ok:
+			v.add("bbox", "must be minLongitude,minLatitude,maxLongitude,maxLatitude")
+		case r.box.minLng < -180 || r.box.maxLng > 180 || r.box.minLat < -90 || r.box.maxLat > 90:
+			v.add("bbox", "must have longitudes between -180 and 180 and latitudes between -90 and 90")
+		case r.box.minLng >= r.box.maxLng || r.box.minLat >= r.box.maxLat:
+			v.add("bbox", "must have minimums below maximums")
+		}
+	}
+	v.timeRange(r.From, r.To)
+	if r.Precision != 0 && (r.Precision < 1 || r.Precision > ledgerGeohashPrecision) {
+		v.add("precision", "must be between 1 and %d", ledgerGeohashPrecision)
+	}
+	return v.errors
+}
+
+// HeatmapBucket counts the incidents and SOS alerts in one geohash cell
+type HeatmapBucket struct {
+	Geohash   string  `json:"geohash"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Incidents int     `json:"incidents"`
+	SOS       int     `json:"sos"`
+}
+
+// Heatmap is the bucketed counts for a heatmap layer. Truncated is set when the area held more than
+// the bucket limit at this precision.
+type Heatmap struct {
+	Precision int             `json:"precision"`
+	Buckets   []HeatmapBucket `json:"buckets"`
+	Truncated bool            `json:"truncated"`
+}
+
+// Heatmap groups located incidents and SOS alerts by geohash prefix. Deleted incidents are left out.
+func (ix *offchainIndex) Heatmap(ctx context.Context, req HeatmapRequest, t tenant) (Heatmap, error) {
+	precision := req.Precision
+	if precision == 0 {
+		precision = defaultHeatmapPrecision
+	}
+
+	var f queryFilter
+	f.add("geohash <> ''")
+	t.restrict(&f, "owner_org")
+	if req.BBox != "" {
+		f.add("latitude BETWEEN ? AND ?", req.box.minLat, req.box.maxLat)
+		f.add("longitude BETWEEN ? AND ?", req.box.minLng, req.box.maxLng)
+	}
+	if req.From != "" {
+		f.add("at >= ?", req.From)
+	}
+	if req.To != "" {
+		f.add("at < ?", req.To)
+	}
+
+	rows, err := ix.db.Query(ctx, fmt.Sprintf(`SELECT left(geohash, %d) AS cell, count(*) FILTER (WHERE kind = 'incident'), count(*) FILTER (WHERE kind = 'sos')
+		FROM (
+			SELECT geohash, latitude, longitude, owner_org, created_at AS at, 'incident' AS kind FROM incidents WHERE deleted_at IS NULL
+			UNION ALL
+			SELECT geohash, latitude, longitude, owner_org, raised_at AS at, 'sos' AS kind FROM sos_alerts
+		) located
+		WHERE %s GROUP BY cell ORDER BY cell LIMIT %d`, precision, f.where(), maxHeatmapBuckets+1), f.args...)
+	if err != nil {
+		return Heatmap{}, err
+	}
+
+	heatmap := Heatmap{Precision: precision, Buckets: make([]HeatmapBucket, 0, len(rows))}
+	if len(rows) > maxHeatmapBuckets {
+		rows, heatmap.Truncated = rows[:maxHeatmapBuckets], true
+	}
+	for _, row := range rows {
+		cell, _ := geohashBounds(row.String(0))
+		heatmap.Buckets = append(heatmap.Buckets, HeatmapBucket{
+			Geohash:   row.String(0),
+			Latitude:  (cell.minLat + cell.maxLat) / 2,
+			Longitude: (cell.minLng + cell.maxLng) / 2,
+			Incidents: int(row.Int(1)),
+			SOS:       int(row.Int(2)),
+		})
+	}
+	return heatmap, nil
+}
+
+// getHeatmap serves the dashboard map layer from the off-chain index, which is the only place
+// incidents can be aggregated by location without reading each one from the peers
+func getHeatmap(c *gin.Context) {
+	var req HeatmapRequest
+	if !bindQuery(c, &req) {
+		return
+	}
+	if offchain == nil {
+		respondError(c, http.StatusServiceUnavailable, errCodeIndexDisabled, "Heatmaps need the off-chain index; set INDEX_DATABASE_URL")
+		return
+	}
+
+	heatmap, err := offchain.Heatmap(c.Request.Context(), req, tenantFromContext(c.Request.Context()))
+	if err != nil {
+		respondServiceError(c, "Failed to build heatmap", err)
+		return
+	}
+
+	respondData(c, http.StatusOK, heatmap)
+}
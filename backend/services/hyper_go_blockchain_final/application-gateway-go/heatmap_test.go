@@ -0,0 +1,47 @@
+package gateway
+
+import (
+	"math"
+	"testing"
+)
+
+func TestGeohash(t *testing.T) {
+	if got := encodeGeohash(57.64911, 10.40744, 11); got != "u4pruydqqvj" {
+		t.Errorf("expected u4pruydqqvj, got %s", got)
+	}
+	if got := encodeGeohash(25.5788, 91.8933, ledgerGeohashPrecision); got != "wh93e0" {
+		t.Errorf("expected wh93e0, got %s", got)
+	}
+
+	cell, ok := geohashBounds("wh93e0")
+	if !ok || !cell.contains(91.8933, 25.5788) {
+		t.Fatalf("expected the cell to contain the encoded point, got %+v", cell)
+	}
+	if width := (cell.maxLng - cell.minLng) * 111.32 * math.Cos(25.5788*math.Pi/180); width > 1.2 {
+		t.Errorf("expected a ledger cell under 1.2 km wide, got %.2f km", width)
+	}
+	if _, ok := geohashBounds("wh3a"); ok {
+		t.Error("expected 'a' to be rejected as outside the geohash alphabet")
+	}
+	if lat, lng := geohashCenter(""); lat != nil || lng != nil {
+		t.Error("expected no coordinates for an incident without a geohash")
+	}
+}
+
+func TestHeatmapRequestValidation(t *testing.T) {
+	valid := HeatmapRequest{BBox: "91.7,25.4, 92.0,25.7", From: "2025-09-01T00:00:00Z", Precision: 6}
+	if errs := valid.Validate(); len(errs) != 0 || valid.box.maxLat != 25.7 {
+		t.Fatalf("unexpected errors %v for %+v", errs, valid.box)
+	}
+	for _, req := range []HeatmapRequest{
+		{BBox: "91.7,25.4,92.0"},
+		{BBox: "92.0,25.4,91.7,25.7"},
+		{BBox: "91.7,-95,92.0,25.7"},
+		{Precision: 7},
+		{From: "2025-09-02T00:00:00Z", To: "2025-09-01T00:00:00Z"},
+	} {
+		if errs := req.Validate(); len(errs) != 1 {
+			t.Errorf("expected one error for %+v, got %v", req, errs)
+		}
+	}
+}
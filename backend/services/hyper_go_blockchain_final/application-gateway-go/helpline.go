@@ -0,0 +1,824 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// helplineReporter reports the incidents helpline calls open and uploads their recordings
+	helplineReporter   = "helpline"
+	helplineCallPrefix = "sih:ivr:call:"
+	helplineStatusPath = "/callbacks/voice/status"
+)
+
+// Helpline call outcomes, as the chaincode records them
+const (
+	callConnected  = "connected"
+	callUnanswered = "unanswered"
+	callAbandoned  = "abandoned"
+)
+
+// CallDocument is a finished helpline call as anchored on the ledger. The caller's number is only
+// kept as a hash salted with the call ID.
+type CallDocument struct {
+	DocType         string `json:"doc_type"`
+	CallID          string `json:"call_id"`
+	Provider        string `json:"provider"`
+	IncidentID      string `json:"incident_id"`
+	CallerHash      string `json:"caller_hash"`
+	UnitID          string `json:"unit_id,omitempty"`
+	Outcome         string `json:"outcome"`
+	StartedAt       string `json:"started_at"`
+	EndedAt         string `json:"ended_at"`
+	DurationSeconds int    `json:"duration_seconds"`
+	RecordingHash   string `json:"recording_hash,omitempty"`
+	EvidenceID      string `json:"evidence_id,omitempty"`
+	AnchoredBy      string `json:"anchored_by"`
+	AnchoredAt      string `json:"anchored_at"`
+	OwnerOrg        string `json:"owner_org,omitempty"`
+	TxID            string `json:"tx_id"`
+}
+
+// helplineResponder is a unit the helpline dials, in the order IVR_RESPONDER_UNITS lists them
+type helplineResponder struct {
+	UnitID string `json:"unit_id"`
+	Phone  string `json:"phone"`
+}
+
+// helplineCall is a call in progress. Provider webhooks each record a part of it, so a dial status
+// and a recording reported concurrently do not overwrite each other; the parts are merged on read.
+type helplineCall struct {
+	CallID     string              `json:"call_id,omitempty"`
+	Provider   string              `json:"provider,omitempty"`
+	IncidentID string              `json:"incident_id,omitempty"`
+	CallerHash string              `json:"caller_hash,omitempty"`
+	StartedAt  string              `json:"started_at,omitempty"`
+	Responders []helplineResponder `json:"responders,omitempty"`
+	// Recording is whether the provider was asked to record the call once answered
+	Recording bool `json:"recording,omitempty"`
+
+	Outcome string `json:"outcome,omitempty"`
+	UnitID  string `json:"unit_id,omitempty"`
+	EndedAt string `json:"ended_at,omitempty"`
+
+	RecordingHash   string `json:"recording_hash,omitempty"`
+	RecordingFailed bool   `json:"recording_failed,omitempty"`
+}
+
+// Parts of a call in the order they are merged
+var helplineCallParts = []string{"call", "outcome", "recording"}
+
+func (c *helplineCall) merge(part helplineCall) {
+	if part.CallID != "" {
+		c.CallID, c.Provider, c.IncidentID, c.CallerHash, c.StartedAt = part.CallID, part.Provider, part.IncidentID, part.CallerHash, part.StartedAt
+		c.Responders, c.Recording = part.Responders, part.Recording
+	}
+	if part.Outcome != "" {
+		c.Outcome, c.UnitID, c.EndedAt = part.Outcome, part.UnitID, part.EndedAt
+	}
+	if part.RecordingHash != "" || part.RecordingFailed {
+		c.RecordingHash, c.RecordingFailed = part.RecordingHash, part.RecordingFailed
+	}
+}
+
+// complete reports whether the call can be anchored: it has ended, and a connected call that was
+// recorded has its recording hashed or given up on
+func (c *helplineCall) complete() bool {
+	if c.CallID == "" || c.Outcome == "" {
+		return false
+	}
+	return c.Outcome != callConnected || !c.Recording || c.RecordingHash != "" || c.RecordingFailed
+}
+
+// evidenceID names the recording's evidence record
+func (c *helplineCall) evidenceID() string {
+	return "REC-" + strings.TrimPrefix(c.CallID, "CALL-")
+}
+
+// helplineStore keeps calls in progress in Redis when the document cache is configured, so any
+// gateway instance can take a call's webhooks, and in memory otherwise
+type helplineStore interface {
+	save(ctx context.Context, callID, part string, call helplineCall) error
+	// load returns the merged call, or nil for a call the store does not know
+	load(ctx context.Context, callID string) (*helplineCall, error)
+}
+
+type redisHelplineStore struct {
+	redis *redisClient
+	ttl   time.Duration
+}
+
+func (s redisHelplineStore) save(ctx context.Context, callID, part string, call helplineCall) error {
+	data, err := json.Marshal(call)
+	if err != nil {
+		return err
+	}
+	key := helplineCallPrefix + callID
+	if _, err := s.redis.Do(ctx, "HSET", key, part, string(data)); err != nil {
+		return err
+	}
+	_, err = s.redis.Do(ctx, "PEXPIRE", key, strconv.FormatInt(s.ttl.Milliseconds(), 10))
+	return err
+}
+
+func (s redisHelplineStore) load(ctx context.Context, callID string) (*helplineCall, error) {
+	parts, err := redisHashJSON[helplineCall](ctx, s.redis, helplineCallPrefix+callID)
+	if err != nil || len(parts) == 0 {
+		return nil, err
+	}
+	return mergeHelplineCall(parts), nil
+}
+
+// memoryHelplineStore serves a single gateway instance
+type memoryHelplineStore struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	calls   map[string]map[string]helplineCall
+	expires map[string]time.Time
+}
+
+func newMemoryHelplineStore(ttl time.Duration) *memoryHelplineStore {
+	return &memoryHelplineStore{ttl: ttl, calls: map[string]map[string]helplineCall{}, expires: map[string]time.Time{}}
+}
+
+func (s *memoryHelplineStore) save(_ context.Context, callID, part string, call helplineCall) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for id, expires := range s.expires {
+		if now.After(expires) {
+			delete(s.calls, id)
+			delete(s.expires, id)
+		}
+	}
+	if s.calls[callID] == nil {
+		s.calls[callID] = map[string]helplineCall{}
+	}
+	s.calls[callID][part] = call
+	s.expires[callID] = now.Add(s.ttl)
+	return nil
+}
+
+func (s *memoryHelplineStore) load(_ context.Context, callID string) (*helplineCall, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if parts, ok := s.calls[callID]; ok && time.Now().Before(s.expires[callID]) {
+		return mergeHelplineCall(parts), nil
+	}
+	return nil, nil
+}
+
+func mergeHelplineCall(parts map[string]helplineCall) *helplineCall {
+	var call helplineCall
+	for _, part := range helplineCallParts {
+		if p, ok := parts[part]; ok {
+			call.merge(p)
+		}
+	}
+	return &call
+}
+
+// voiceEvent is a provider webhook's view of a call. DialStatus is the outcome of dialling a
+// responder; Attempt is the index of the responder dialled and DialedNumber the number answering,
+// whichever the provider reports.
+type voiceEvent struct {
+	CallSID         string
+	From            string
+	CallStatus      string
+	DialStatus      string
+	DialedNumber    string
+	Attempt         int
+	RecordingURL    string
+	RecordingStatus string
+}
+
+// voiceProvider answers the helpline for a telephony provider. Twilio is dialled one responder at
+// a time, with each unanswered dial reported back; Exotel is handed every number at once.
+type voiceProvider interface {
+	name() string
+	// authorized checks that a webhook came from the provider
+	authorized(r *http.Request) bool
+	event(r *http.Request) voiceEvent
+	// connect answers by dialling the responders from attempt on, recording once answered
+	connect(c *gin.Context, greeting string, responders []helplineResponder, attempt int, record bool)
+	// hangup ends the call, saying message first when there is one
+	hangup(c *gin.Context, message string)
+	// dialsEach reports whether the gateway dials the next responder itself when one does not answer
+	dialsEach() bool
+	// recordingRequest builds the authenticated request downloading a recording
+	recordingRequest(ctx context.Context, recordingURL string) (*http.Request, error)
+}
+
+// twilioVoice answers through TwiML. Webhooks are verified with the X-Twilio-Signature header
+// against IVR_PUBLIC_URL, the gateway's address as Twilio calls it.
+type twilioVoice struct {
+	accountSID  string
+	authToken   string
+	publicURL   string
+	dialTimeout int
+}
+
+type twimlResponse struct {
+	XMLName xml.Name   `xml:"Response"`
+	Say     string     `xml:"Say,omitempty"`
+	Dial    *twimlDial `xml:"Dial,omitempty"`
+	Hangup  *struct{}  `xml:"Hangup,omitempty"`
+}
+
+type twimlDial struct {
+	Action                  string `xml:"action,attr"`
+	Timeout                 int    `xml:"timeout,attr"`
+	Record                  string `xml:"record,attr,omitempty"`
+	RecordingStatusCallback string `xml:"recordingStatusCallback,attr,omitempty"`
+	Number                  string `xml:"Number"`
+}
+
+func (t *twilioVoice) name() string { return "twilio" }
+
+func (t *twilioVoice) authorized(r *http.Request) bool {
+	if t.publicURL == "" || r.ParseForm() != nil {
+		return false
+	}
+	expected := twilioSignature(t.authToken, t.publicURL+r.URL.RequestURI(), r.PostForm)
+	return hmac.Equal([]byte(r.Header.Get("X-Twilio-Signature")), []byte(expected))
+}
+
+func (t *twilioVoice) event(r *http.Request) voiceEvent {
+	attempt, _ := strconv.Atoi(r.URL.Query().Get("attempt"))
+	return voiceEvent{
+		CallSID:         r.Form.Get("CallSid"),
+		From:            r.Form.Get("From"),
+		CallStatus:      r.Form.Get("CallStatus"),
+		DialStatus:      r.Form.Get("DialCallStatus"),
+		Attempt:         attempt,
+		RecordingURL:    r.Form.Get("RecordingUrl"),
+		RecordingStatus: r.Form.Get("RecordingStatus"),
+	}
+}
+
+func (t *twilioVoice) connect(c *gin.Context, greeting string, responders []helplineResponder, attempt int, record bool) {
+	dial := &twimlDial{
+		Action:  helplineStatusPath + "?attempt=" + strconv.Itoa(attempt),
+		Timeout: t.dialTimeout,
+		Number:  responders[attempt].Phone,
+	}
+	if record {
+		dial.Record, dial.RecordingStatusCallback = "record-from-answer-dual", helplineStatusPath
+	}
+	c.XML(http.StatusOK, twimlResponse{Say: greeting, Dial: dial})
+}
+
+func (t *twilioVoice) hangup(c *gin.Context, message string) {
+	c.XML(http.StatusOK, twimlResponse{Say: message, Hangup: &struct{}{}})
+}
+
+func (t *twilioVoice) dialsEach() bool { return true }
+
+func (t *twilioVoice) recordingRequest(ctx context.Context, recordingURL string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, recordingURL+".mp3", nil)
+	if err == nil {
+		req.SetBasicAuth(t.accountSID, t.authToken)
+	}
+	return req, err
+}
+
+// exotelVoice answers through a Connect applet's dynamic URL and learns the outcome from a
+// Passthru applet placed after it. Both URLs carry ?token=IVR_CALLBACK_TOKEN.
+type exotelVoice struct {
+	apiKey        string
+	apiToken      string
+	callbackToken string
+	dialTimeout   int
+}
+
+type exotelConnect struct {
+	Destination        exotelDestination `json:"destination"`
+	Record             bool              `json:"record"`
+	RecordingChannels  string            `json:"recording_channels,omitempty"`
+	MaxRingingDuration int               `json:"max_ringing_duration"`
+	StartCallPlayback  *exotelPlayback   `json:"start_call_playback,omitempty"`
+}
+
+type exotelDestination struct {
+	Numbers []string `json:"numbers"`
+}
+
+type exotelPlayback struct {
+	PlaybackTo string `json:"playback_to"`
+	Type       string `json:"type"`
+	Value      string `json:"value"`
+}
+
+func (e *exotelVoice) name() string { return "exotel" }
+
+func (e *exotelVoice) authorized(r *http.Request) bool {
+	return r.ParseForm() == nil && validCallbackToken(r, e.callbackToken)
+}
+
+func (e *exotelVoice) event(r *http.Request) voiceEvent {
+	ev := voiceEvent{
+		CallSID:      r.Form.Get("CallSid"),
+		From:         r.Form.Get("CallFrom"),
+		DialStatus:   r.Form.Get("DialCallStatus"),
+		DialedNumber: r.Form.Get("DialWhomNumber"),
+		RecordingURL: r.Form.Get("RecordingUrl"),
+	}
+	if ev.RecordingURL != "" {
+		ev.RecordingStatus = "completed"
+	}
+	return ev
+}
+
+func (e *exotelVoice) connect(c *gin.Context, greeting string, responders []helplineResponder, _ int, record bool) {
+	response := exotelConnect{Record: record, MaxRingingDuration: e.dialTimeout}
+	for _, responder := range responders {
+		response.Destination.Numbers = append(response.Destination.Numbers, responder.Phone)
+	}
+	if record {
+		response.RecordingChannels = "dual"
+	}
+	if greeting != "" {
+		response.StartCallPlayback = &exotelPlayback{PlaybackTo: "both", Type: "text", Value: greeting}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// hangup has nothing to say to Exotel: with no numbers to connect, the flow moves on to the applet
+// after Connect, which plays the fallback message
+func (e *exotelVoice) hangup(c *gin.Context, _ string) {
+	c.Status(http.StatusNoContent)
+}
+
+func (e *exotelVoice) dialsEach() bool { return false }
+
+func (e *exotelVoice) recordingRequest(ctx context.Context, recordingURL string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, recordingURL, nil)
+	if err == nil && e.apiKey != "" {
+		req.SetBasicAuth(e.apiKey, e.apiToken)
+	}
+	return req, err
+}
+
+// helplineService connects callers to the emergency helpline with the on-duty responder, opens an
+// incident for every call and anchors the call, with its recording's hash as evidence, once it ends
+type helplineService struct {
+	provider    voiceProvider
+	responders  []string
+	greeting    string
+	unavailable string
+	record      bool
+	severity    string
+	countryCode string
+	// rosterLocation places unit shifts when dispatch, which has its own, is not configured
+	rosterLocation *time.Location
+	store          helplineStore
+	httpClient     *http.Client
+}
+
+var helpline *helplineService
+
+// initHelpline configures the provider named by IVR_PROVIDER. Runs after initDispatch so the
+// helpline dials units the roster shows available.
+func initHelpline() {
+	kind := getEnv("IVR_PROVIDER", "")
+	if kind == "" {
+		log.Println("📞 IVR_PROVIDER not set, helpline calls disabled")
+		return
+	}
+	dialTimeout := int(getEnvDuration("IVR_DIAL_TIMEOUT", 20*time.Second).Seconds())
+	countryCode := getEnv("SMS_DEFAULT_COUNTRY_CODE", "+91")
+	var provider voiceProvider
+	switch kind {
+	case "twilio":
+		provider = &twilioVoice{
+			accountSID:  getEnv("TWILIO_ACCOUNT_SID", ""),
+			authToken:   getEnv("TWILIO_AUTH_TOKEN", ""),
+			publicURL:   strings.TrimSuffix(getEnv("IVR_PUBLIC_URL", ""), "/"),
+			dialTimeout: dialTimeout,
+		}
+	case "exotel":
+		provider = &exotelVoice{
+			apiKey:        getEnv("EXOTEL_API_KEY", ""),
+			apiToken:      getEnv("EXOTEL_API_TOKEN", ""),
+			callbackToken: getEnv("IVR_CALLBACK_TOKEN", ""),
+			dialTimeout:   dialTimeout,
+		}
+	default:
+		panic(fmt.Errorf("unknown IVR_PROVIDER %q, expected twilio or exotel", kind))
+	}
+
+	responders := splitList(getEnv("IVR_RESPONDER_UNITS", ""))
+	if len(responders) == 0 {
+		panic(fmt.Errorf("IVR_RESPONDER_UNITS must list the units answering the helpline"))
+	}
+	for _, id := range responders {
+		if unit := findPoliceUnit(id); unit == nil || unit.Phone == "" {
+			panic(fmt.Errorf("IVR_RESPONDER_UNITS names %q, which is not a unit with a phone number", id))
+		}
+	}
+	severity := getEnv("IVR_INCIDENT_SEVERITY", "high")
+	if !slices.Contains(incidentSeverities, severity) {
+		panic(fmt.Errorf("IVR_INCIDENT_SEVERITY %q is not an incident severity", severity))
+	}
+	timezone := getEnv("ROSTER_TIMEZONE", defaultZoneTimezone)
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		panic(fmt.Errorf("ROSTER_TIMEZONE %q is not a known timezone", timezone))
+	}
+	ttl := getEnvDuration("IVR_CALL_TTL", 24*time.Hour)
+	var store helplineStore = newMemoryHelplineStore(ttl)
+	if documentCache != nil {
+		store = redisHelplineStore{documentCache, ttl}
+	}
+	helpline = &helplineService{
+		provider:       provider,
+		responders:     responders,
+		greeting:       getEnv("IVR_GREETING", "Tourist safety helpline. Connecting you to the duty officer. This call is recorded."),
+		unavailable:    getEnv("IVR_UNAVAILABLE_MESSAGE", "No officer is available right now. Your call has been logged. Please call 112."),
+		record:         getEnvBool("IVR_RECORD", true),
+		severity:       severity,
+		countryCode:    countryCode,
+		rosterLocation: location,
+		store:          store,
+		httpClient:     &http.Client{Timeout: getEnvDuration("IVR_RECORDING_TIMEOUT", 2*time.Minute)},
+	}
+	log.Printf("📞 Helpline calls answered through %s by %d units", kind, len(responders))
+}
+
+func findPoliceUnit(id string) *PoliceUnit {
+	for i := range policeUnits {
+		if policeUnits[i].ID == id {
+			return &policeUnits[i]
+		}
+	}
+	return nil
+}
+
+// onDuty returns the helpline's responders that can take a call now, in IVR_RESPONDER_UNITS order.
+// With dispatch configured that is the units the roster shows available; otherwise those on shift.
+func (h *helplineService) onDuty(ctx context.Context, now time.Time) ([]helplineResponder, error) {
+	available := map[string]bool{}
+	if dispatcher != nil {
+		units, err := dispatcher.units(ctx, now)
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range units {
+			available[u.ID] = u.Status == "available"
+		}
+	} else {
+		for _, u := range policeUnits {
+			available[u.ID] = onShift(u.Shifts, now, h.rosterLocation)
+		}
+	}
+	responders := []helplineResponder{}
+	for _, id := range h.responders {
+		if unit := findPoliceUnit(id); unit != nil && unit.Phone != "" && available[id] {
+			responders = append(responders, helplineResponder{UnitID: id, Phone: unit.Phone})
+		}
+	}
+	return responders, nil
+}
+
+// answeredBy returns the responder the event says answered: the number Exotel reports, or the
+// responder Twilio was dialling
+func (h *helplineService) answeredBy(call *helplineCall, ev voiceEvent) string {
+	if ev.DialedNumber == "" {
+		if ev.Attempt >= 0 && ev.Attempt < len(call.Responders) {
+			return call.Responders[ev.Attempt].UnitID
+		}
+		return ""
+	}
+	dialed, err := normalizeMobile(ev.DialedNumber, h.countryCode)
+	if err != nil {
+		return ""
+	}
+	for _, r := range call.Responders {
+		if phone, err := normalizeMobile(r.Phone, h.countryCode); err == nil && phone == dialed {
+			return r.UnitID
+		}
+	}
+	return ""
+}
+
+// dialOutcome maps a dial status to the call's outcome. It returns no outcome when the next
+// responder should be dialled: the caller is still on the line and the gateway dials each in turn.
+func (h *helplineService) dialOutcome(call *helplineCall, ev voiceEvent) (outcome, unitID string) {
+	switch ev.DialStatus {
+	case "completed", "answered":
+		if unitID = h.answeredBy(call, ev); unitID != "" {
+			return callConnected, unitID
+		}
+		log.Printf("📞 Call %s was answered by a number that is not a responder", call.CallID)
+		return callUnanswered, ""
+	case "canceled":
+		return callAbandoned, ""
+	}
+	if ev.CallStatus == "completed" {
+		return callAbandoned, ""
+	}
+	if h.provider.dialsEach() && ev.Attempt+1 < len(call.Responders) {
+		return "", ""
+	}
+	return callUnanswered, ""
+}
+
+func helplineCallSID(sid string) bool {
+	return identifierRegex.MatchString(sid) && len(sid) <= 96
+}
+
+func helplineIncoming(c *gin.Context) {
+	if helpline == nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	if !helpline.provider.authorized(c.Request) {
+		c.Status(http.StatusForbidden)
+		return
+	}
+	ev := helpline.provider.event(c.Request)
+	if !helplineCallSID(ev.CallSID) || ev.From == "" {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+	ctx := context.WithoutCancel(c.Request.Context())
+	now := time.Now().UTC()
+
+	callID := "CALL-" + ev.CallSID
+	callerHash := sha256.Sum256([]byte(callID + "|" + ev.From))
+	call := helplineCall{
+		CallID:     callID,
+		Provider:   helpline.provider.name(),
+		IncidentID: "HELPLINE-" + ev.CallSID,
+		CallerHash: hex.EncodeToString(callerHash[:]),
+		StartedAt:  now.Format(time.RFC3339),
+		Recording:  helpline.record,
+	}
+	responders, err := helpline.onDuty(ctx, now)
+	if err != nil {
+		log.Printf("📞 Failed to read the roster for call %s, dialling every responder: %v", callID, err)
+		for _, id := range helpline.responders {
+			responders = append(responders, helplineResponder{UnitID: id, Phone: findPoliceUnit(id).Phone})
+		}
+	}
+	call.Responders = responders
+	if err := helpline.store.save(ctx, callID, "call", call); err != nil {
+		log.Printf("📞 Failed to record call %s: %v", callID, err)
+	}
+
+	if len(responders) == 0 {
+		log.Printf("📞 No responder on duty for call %s", callID)
+		outcome := helplineCall{Outcome: callUnanswered, EndedAt: now.Format(time.RFC3339)}
+		if err := helpline.store.save(ctx, callID, "outcome", outcome); err != nil {
+			log.Printf("📞 Failed to record the outcome of call %s: %v", callID, err)
+		}
+		go helpline.finish(ctx, callID)
+		helpline.provider.hangup(c, helpline.unavailable)
+		return
+	}
+	go helpline.openIncident(ctx, call)
+	helpline.provider.connect(c, helpline.greeting, responders, 0, helpline.record)
+}
+
+func helplineStatus(c *gin.Context) {
+	if helpline == nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	if !helpline.provider.authorized(c.Request) {
+		c.Status(http.StatusForbidden)
+		return
+	}
+	ev := helpline.provider.event(c.Request)
+	if !helplineCallSID(ev.CallSID) {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+	ctx := context.WithoutCancel(c.Request.Context())
+	callID := "CALL-" + ev.CallSID
+	call, err := helpline.store.load(ctx, callID)
+	if err != nil || call == nil || call.CallID == "" {
+		if err != nil {
+			log.Printf("📞 Failed to load call %s: %v", callID, err)
+		}
+		helpline.provider.hangup(c, "")
+		return
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+
+	var outcome helplineCall
+	switch {
+	case ev.DialStatus != "":
+		outcome.Outcome, outcome.UnitID = helpline.dialOutcome(call, ev)
+		if outcome.Outcome == "" {
+			helpline.provider.connect(c, "", call.Responders, ev.Attempt+1, call.Recording)
+			return
+		}
+	case ev.CallStatus == "completed" && call.Outcome == "":
+		outcome.Outcome = callAbandoned
+	}
+	if outcome.Outcome != "" && call.Outcome == "" {
+		outcome.EndedAt = now
+		if err := helpline.store.save(ctx, callID, "outcome", outcome); err != nil {
+			log.Printf("📞 Failed to record the outcome of call %s: %v", callID, err)
+		}
+		go helpline.finish(ctx, callID)
+	}
+
+	switch ev.RecordingStatus {
+	case "completed":
+		if ev.RecordingURL != "" && call.RecordingHash == "" {
+			go helpline.hashRecording(ctx, callID, ev.RecordingURL)
+		}
+	case "failed", "absent":
+		if err := helpline.store.save(ctx, callID, "recording", helplineCall{RecordingFailed: true}); err != nil {
+			log.Printf("📞 Failed to record the recording of call %s: %v", callID, err)
+		}
+		go helpline.finish(ctx, callID)
+	}
+	helpline.provider.hangup(c, "")
+}
+
+// hashRecording streams the recording from the provider through SHA-256. The recording itself
+// stays with the provider; a recording that cannot be fetched is given up on so the call is still
+// anchored.
+func (h *helplineService) hashRecording(ctx context.Context, callID, recordingURL string) {
+	part := helplineCall{RecordingFailed: true}
+	if sum, err := h.fetchRecordingHash(ctx, recordingURL); err != nil {
+		log.Printf("📞 Failed to hash the recording of call %s: %v", callID, err)
+	} else {
+		part = helplineCall{RecordingHash: sum}
+	}
+	if err := h.store.save(ctx, callID, "recording", part); err != nil {
+		log.Printf("📞 Failed to record the recording of call %s: %v", callID, err)
+	}
+	h.finish(ctx, callID)
+}
+
+func (h *helplineService) fetchRecordingHash(ctx context.Context, recordingURL string) (string, error) {
+	req, err := h.provider.recordingRequest(ctx, recordingURL)
+	if err != nil {
+		return "", err
+	}
+	resp, err := h.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s responded with %d", h.provider.name(), resp.StatusCode)
+	}
+	digest := sha256.New()
+	if _, err := io.Copy(digest, resp.Body); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(digest.Sum(nil)), nil
+}
+
+// openIncident records the incident a call opens. The summary hash covers the call's metadata, not
+// what the caller said.
+func (h *helplineService) openIncident(ctx context.Context, call helplineCall) error {
+	summary, _ := json.Marshal(map[string]string{
+		"call_id":     call.CallID,
+		"provider":    call.Provider,
+		"caller_hash": call.CallerHash,
+		"started_at":  call.StartedAt,
+	})
+	sum := sha256.Sum256(summary)
+	_, err := ledger.CreateIncident(ctx, CreateIncidentRequest{
+		IncidentID:          call.IncidentID,
+		IncidentSummaryHash: hex.EncodeToString(sum[:]),
+		Reporter:            helplineReporter,
+		Severity:            h.severity,
+		Category:            "other",
+	})
+	if err != nil && translateFabricError(err).Code != errCodeAlreadyExists {
+		log.Printf("📞 Failed to open incident %s for call %s: %v", call.IncidentID, call.CallID, err)
+		return err
+	}
+	return nil
+}
+
+// finish anchors a call once it is complete: the incident it opened, the recording's hash as
+// evidence, then the call itself. Each step tolerates having been done by a concurrent webhook.
+func (h *helplineService) finish(ctx context.Context, callID string) {
+	call, err := h.store.load(ctx, callID)
+	if err != nil {
+		log.Printf("📞 Failed to load call %s: %v", callID, err)
+		return
+	}
+	if call == nil || !call.complete() {
+		return
+	}
+	if err := h.openIncident(ctx, *call); err != nil {
+		return
+	}
+	evidenceID := ""
+	if call.RecordingHash != "" {
+		evidenceID = call.evidenceID()
+		_, err := ledger.CreateEvidence(ctx, CreateEvidenceRequest{
+			EvidenceID:   evidenceID,
+			EvidenceHash: call.RecordingHash,
+			IncidentID:   call.IncidentID,
+			MediaType:    "audio/mpeg",
+			UploadedBy:   helplineReporter,
+		})
+		if err != nil && translateFabricError(err).Code != errCodeAlreadyExists {
+			log.Printf("📞 Failed to record the recording of call %s as evidence: %v", callID, err)
+			return
+		}
+	}
+	_, err = submitTransaction(ctx, "AnchorCall", call.CallID, call.IncidentID, call.Provider, call.CallerHash, call.UnitID,
+		call.Outcome, call.StartedAt, call.EndedAt, call.RecordingHash, evidenceID, helplineReporter)
+	if err != nil && translateFabricError(err).Code != errCodeAlreadyExists {
+		log.Printf("📞 Failed to anchor call %s: %v", callID, err)
+		return
+	}
+	log.Printf("📞 Anchored %s call %s against incident %s", call.Outcome, callID, call.IncidentID)
+}
+
+// GetCall reads a helpline call the caller's organization took
+func (ledgerService) GetCall(ctx context.Context, callID string) (CallDocument, error) {
+	if errs := validateDocumentID("id", callID); len(errs) > 0 {
+		return CallDocument{}, errs
+	}
+	result, err := readDocument(ctx, "ReadCall", callID)
+	if err != nil {
+		return CallDocument{}, err
+	}
+	call, err := decodeDocument[CallDocument](result, "call")
+	if err == nil && !tenantFromContext(ctx).owns(call.OwnerOrg) {
+		return CallDocument{}, fmt.Errorf("the call %s does not exist", callID)
+	}
+	return call, err
+}
+
+// ListCallsByIncident returns the helpline calls anchored against an incident, earliest first
+func (ledgerService) ListCallsByIncident(ctx context.Context, incidentID string) ([]CallDocument, error) {
+	if errs := validateDocumentID("id", incidentID); len(errs) > 0 {
+		return nil, errs
+	}
+	result, err := evaluateTransaction(ctx, "GetCallsByIncident", incidentID)
+	if err != nil {
+		return nil, err
+	}
+	calls, err := decodeDocument[[]CallDocument](result, "call")
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(calls, func(i, j int) bool { return calls[i].StartedAt < calls[j].StartedAt })
+	return filterOwned(tenantFromContext(ctx), calls, func(c CallDocument) string { return c.OwnerOrg }), nil
+}
+
+func getHelplineCall(c *gin.Context) {
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	call, err := ledger.GetCall(c.Request.Context(), id)
+	if err != nil {
+		respondServiceError(c, "Failed to read call", err)
+		return
+	}
+	respondTagged(c, call, call.TxID)
+}
+
+func getIncidentCalls(c *gin.Context) {
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	calls, err := ledger.ListCallsByIncident(c.Request.Context(), id)
+	if err != nil {
+		respondServiceError(c, "Failed to get calls by incident", err)
+		return
+	}
+	txIDs := make([]string, 0, len(calls))
+	for _, call := range calls {
+		txIDs = append(txIDs, call.TxID)
+	}
+	respondTagged(c, calls, txIDs...)
+}
@@ -0,0 +1,133 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestHelplineTwilioRedial(t *testing.T) {
+	provider := &twilioVoice{accountSID: "AC1", authToken: "twilio-token", publicURL: "https://gateway.example.org", dialTimeout: 20}
+	store := newMemoryHelplineStore(time.Hour)
+	helpline = &helplineService{provider: provider, record: true, countryCode: "+91", rosterLocation: time.UTC, store: store}
+	defer func() { helpline = nil }()
+	responders := []helplineResponder{{UnitID: "sadar", Phone: "+913642222222"}, {UnitID: "laitumkhrah", Phone: "+913642333333"}}
+	if err := store.save(context.Background(), "CALL-CA1", "call", helplineCall{CallID: "CALL-CA1", Provider: "twilio", IncidentID: "HELPLINE-CA1", CallerHash: "h", StartedAt: "2025-09-20T13:00:00Z", Responders: responders, Recording: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST(helplineStatusPath, helplineStatus)
+	post := func(target string, form url.Values, signature string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("X-Twilio-Signature", signature)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	form := url.Values{"CallSid": {"CA1"}, "CallStatus": {"in-progress"}, "DialCallStatus": {"no-answer"}}
+	target := helplineStatusPath + "?attempt=0"
+	if w := post(target, form, "forged"); w.Code != http.StatusForbidden {
+		t.Errorf("expected a forged signature rejected, got %d", w.Code)
+	}
+	// The signature covers the public URL with its query, so the attempt cannot be replayed
+	signature := twilioSignature("twilio-token", "https://gateway.example.org"+target, form)
+	if w := post(helplineStatusPath+"?attempt=1", form, signature); w.Code != http.StatusForbidden {
+		t.Errorf("expected a signature for another URL rejected, got %d", w.Code)
+	}
+
+	// The first responder did not answer and the caller is still on the line: dial the next
+	w := post(target, form, signature)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `<Dial action="/callbacks/voice/status?attempt=1" timeout="20" record="record-from-answer-dual" recordingStatusCallback="/callbacks/voice/status"><Number>+913642333333</Number></Dial>`) {
+		t.Errorf("expected the second responder dialled, got %d %s", w.Code, w.Body)
+	}
+	if call, _ := store.load(context.Background(), "CALL-CA1"); call.Outcome != "" {
+		t.Errorf("expected no outcome while responders remain, got %+v", call)
+	}
+}
+
+func TestHelplineConnect(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	responders := []helplineResponder{{UnitID: "sadar", Phone: "+913642222222"}, {UnitID: "laitumkhrah", Phone: "+913642333333"}}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	(&twilioVoice{dialTimeout: 20}).connect(c, "Tourist helpline.", responders, 0, false)
+	if want := `<Response><Say>Tourist helpline.</Say><Dial action="/callbacks/voice/status?attempt=0" timeout="20"><Number>+913642222222</Number></Dial></Response>`; w.Body.String() != want {
+		t.Errorf("unexpected TwiML %s", w.Body)
+	}
+
+	// Exotel dials every responder itself, in order
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	(&exotelVoice{dialTimeout: 20}).connect(c, "Tourist helpline.", responders, 0, true)
+	var connect exotelConnect
+	if err := json.Unmarshal(w.Body.Bytes(), &connect); err != nil {
+		t.Fatal(err)
+	}
+	if len(connect.Destination.Numbers) != 2 || !connect.Record || connect.RecordingChannels != "dual" || connect.StartCallPlayback.Value != "Tourist helpline." {
+		t.Errorf("unexpected Connect response %s", w.Body)
+	}
+}
+
+func TestHelplineCallOutcome(t *testing.T) {
+	twilio := &helplineService{provider: &twilioVoice{}, countryCode: "+91"}
+	exotel := &helplineService{provider: &exotelVoice{}, countryCode: "+91"}
+	call := &helplineCall{CallID: "CALL-1", Responders: []helplineResponder{{UnitID: "sadar", Phone: "+913642222222"}, {UnitID: "laitumkhrah", Phone: "+91 3642 333333"}}}
+
+	cases := []struct {
+		helpline *helplineService
+		event    voiceEvent
+		outcome  string
+		unitID   string
+	}{
+		{twilio, voiceEvent{DialStatus: "completed", Attempt: 1}, callConnected, "laitumkhrah"},
+		{twilio, voiceEvent{DialStatus: "busy", Attempt: 0}, "", ""},
+		{twilio, voiceEvent{DialStatus: "no-answer", Attempt: 1}, callUnanswered, ""},
+		{twilio, voiceEvent{DialStatus: "no-answer", CallStatus: "completed"}, callAbandoned, ""},
+		{twilio, voiceEvent{DialStatus: "canceled"}, callAbandoned, ""},
+		// Exotel reports the number that answered in national form, and dials every responder itself
+		{exotel, voiceEvent{DialStatus: "completed", DialedNumber: "03642333333"}, callConnected, "laitumkhrah"},
+		{exotel, voiceEvent{DialStatus: "no-answer"}, callUnanswered, ""},
+		{exotel, voiceEvent{DialStatus: "completed", DialedNumber: "09999999999"}, callUnanswered, ""},
+	}
+	for _, tc := range cases {
+		if outcome, unitID := tc.helpline.dialOutcome(call, tc.event); outcome != tc.outcome || unitID != tc.unitID {
+			t.Errorf("%s %+v: expected %q %q, got %q %q", tc.helpline.provider.name(), tc.event, tc.outcome, tc.unitID, outcome, unitID)
+		}
+	}
+}
+
+func TestHelplineCallComplete(t *testing.T) {
+	store := newMemoryHelplineStore(time.Hour)
+	ctx := context.Background()
+	store.save(ctx, "CALL-1", "call", helplineCall{CallID: "CALL-1", IncidentID: "HELPLINE-1", StartedAt: "2025-09-20T13:00:00Z", Recording: true})
+	store.save(ctx, "CALL-1", "outcome", helplineCall{Outcome: callConnected, UnitID: "sadar", EndedAt: "2025-09-20T13:04:00Z"})
+	call, _ := store.load(ctx, "CALL-1")
+	if call.complete() {
+		t.Error("expected a recorded call to wait for its recording")
+	}
+	store.save(ctx, "CALL-1", "recording", helplineCall{RecordingHash: strings.Repeat("a", 64)})
+	if call, _ = store.load(ctx, "CALL-1"); !call.complete() || call.UnitID != "sadar" || call.IncidentID != "HELPLINE-1" || call.evidenceID() != "REC-1" {
+		t.Errorf("expected the parts merged into a complete call, got %+v", call)
+	}
+
+	// A failed recording, or a call nobody answered, does not hold the call back
+	if !(&helplineCall{CallID: "CALL-2", Outcome: callConnected, Recording: true, RecordingFailed: true}).complete() ||
+		!(&helplineCall{CallID: "CALL-3", Outcome: callUnanswered, Recording: true}).complete() {
+		t.Error("expected the call complete without a recording")
+	}
+	if call, _ := store.load(ctx, "CALL-9"); call != nil {
+		t.Errorf("expected an unknown call, got %+v", call)
+	}
+}
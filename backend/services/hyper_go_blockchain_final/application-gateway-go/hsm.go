@@ -0,0 +1,113 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// hsmConfig locates an identity's private key on a PKCS#11 token. Empty fields fall back to
+// PKCS11_LABEL and PKCS11_PIN, and by default the key is found by its certificate's subject key
+// identifier, the CKA_ID Fabric tooling assigns when it generates keys on a token.
+type hsmConfig struct {
+	Label string `json:"label,omitempty"`
+	// PinEnv names the environment variable holding the token PIN, so PINs stay out of wallet files
+	PinEnv     string `json:"pin_env,omitempty"`
+	Identifier string `json:"identifier,omitempty"`
+}
+
+// hsmSignerOptions is a resolved hsmConfig; identifier holds the raw CKA_ID bytes
+type hsmSignerOptions struct {
+	library    string
+	label      string
+	pin        string
+	identifier string
+}
+
+var (
+	hsmClosersMu sync.Mutex
+	hsmClosers   []func() error
+)
+
+// options resolves the token, PIN and key identifier for the key behind cert
+func (cfg hsmConfig) options(cert *x509.Certificate) (hsmSignerOptions, error) {
+	opts := hsmSignerOptions{library: getEnv("PKCS11_LIBRARY", ""), label: cfg.Label}
+	if opts.label == "" {
+		opts.label = getEnv("PKCS11_LABEL", "")
+	}
+	pinEnv := cfg.PinEnv
+	if pinEnv == "" {
+		pinEnv = "PKCS11_PIN"
+	}
+	opts.pin = getEnv(pinEnv, "")
+
+	if cfg.Identifier != "" {
+		id, err := hex.DecodeString(cfg.Identifier)
+		if err != nil {
+			return hsmSignerOptions{}, fmt.Errorf("HSM key identifier must be hex: %w", err)
+		}
+		opts.identifier = string(id)
+	} else {
+		ski, err := certificateSKI(cert)
+		if err != nil {
+			return hsmSignerOptions{}, err
+		}
+		opts.identifier = string(ski)
+	}
+
+	switch {
+	case opts.library == "":
+		return hsmSignerOptions{}, errors.New("PKCS11_LIBRARY is not set")
+	case opts.label == "":
+		return hsmSignerOptions{}, errors.New("no PKCS#11 token label configured")
+	case opts.pin == "":
+		return hsmSignerOptions{}, fmt.Errorf("%s is not set", pinEnv)
+	}
+	return opts, nil
+}
+
+// certificateSKI computes the subject key identifier Fabric uses for an ECDSA key: the SHA-256 of
+// the uncompressed public point
+func certificateSKI(cert *x509.Certificate) ([]byte, error) {
+	publicKey, ok := cert.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("HSM signing requires an ECDSA certificate, got %T", cert.PublicKey)
+	}
+	point, err := publicKey.ECDH()
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(point.Bytes())
+	return sum[:], nil
+}
+
+// registerHSMCloser remembers an HSM session to close at shutdown
+func registerHSMCloser(closer func() error) {
+	hsmClosersMu.Lock()
+	defer hsmClosersMu.Unlock()
+	hsmClosers = append(hsmClosers, closer)
+}
+
+// closeHSMSigners logs out of every HSM session opened by the gateway
+func closeHSMSigners() {
+	hsmClosersMu.Lock()
+	defer hsmClosersMu.Unlock()
+	for _, closer := range hsmClosers {
+		if err := closer(); err != nil {
+			log.Printf("Failed to close HSM session: %v", err)
+		}
+	}
+	hsmClosers = nil
+	disposeHSM()
+}
@@ -0,0 +1,24 @@
+//go:build !pkcs11
+
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"errors"
+
+	"github.com/hyperledger/fabric-gateway/pkg/identity"
+)
+
+var errNoPKCS11 = errors.New("HSM signing requires a gateway built with -tags pkcs11")
+
+// newHSMSign is unavailable without cgo PKCS#11 support
+func newHSMSign(hsmSignerOptions) (identity.Sign, error) {
+	return nil, errNoPKCS11
+}
+
+func disposeHSM() {}
@@ -0,0 +1,51 @@
+//go:build pkcs11
+
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/hyperledger/fabric-gateway/pkg/identity"
+)
+
+// A single factory must be shared by every signer, since the PKCS#11 library is initialised once
+// per process
+var (
+	hsmFactoryOnce sync.Once
+	hsmFactory     *identity.HSMSignerFactory
+	hsmFactoryErr  error
+)
+
+// newHSMSign opens a session on the token and returns a signer for the key it holds
+func newHSMSign(opts hsmSignerOptions) (identity.Sign, error) {
+	hsmFactoryOnce.Do(func() {
+		hsmFactory, hsmFactoryErr = identity.NewHSMSignerFactory(opts.library)
+	})
+	if hsmFactoryErr != nil {
+		return nil, fmt.Errorf("failed to load PKCS#11 library %s: %w", opts.library, hsmFactoryErr)
+	}
+
+	sign, closeSign, err := hsmFactory.NewHSMSigner(identity.HSMSignerOptions{
+		Label:      opts.label,
+		Pin:        opts.pin,
+		Identifier: opts.identifier,
+	})
+	if err != nil {
+		return nil, err
+	}
+	registerHSMCloser(closeSign)
+	return sign, nil
+}
+
+func disposeHSM() {
+	if hsmFactory != nil {
+		hsmFactory.Dispose()
+	}
+}
@@ -0,0 +1,44 @@
+package gateway
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"testing"
+)
+
+func TestHSMConfigOptions(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := &x509.Certificate{PublicKey: &key.PublicKey}
+
+	t.Setenv("PKCS11_LIBRARY", "/usr/lib/softhsm/libsofthsm2.so")
+	t.Setenv("PKCS11_LABEL", "ForFabric")
+	t.Setenv("PKCS11_PIN", "98765432")
+	t.Setenv("ISSUER_PIN", "1234")
+
+	opts, err := hsmConfig{}.options(cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ski := sha256.Sum256(elliptic.Marshal(elliptic.P256(), key.X, key.Y))
+	if opts.label != "ForFabric" || opts.pin != "98765432" || opts.identifier != string(ski[:]) {
+		t.Errorf("unexpected options %+v", opts)
+	}
+
+	opts, err = hsmConfig{Label: "Issuer", PinEnv: "ISSUER_PIN", Identifier: "0a0b"}.options(cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.label != "Issuer" || opts.pin != "1234" || opts.identifier != "\x0a\x0b" {
+		t.Errorf("unexpected per-identity options %+v", opts)
+	}
+
+	if _, err := (hsmConfig{PinEnv: "UNSET_PIN"}).options(cert); err == nil {
+		t.Error("expected a missing PIN to be rejected")
+	}
+}
@@ -0,0 +1,251 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sourceLanguage is the language API messages are written in; it needs no catalog
+const sourceLanguage = "en"
+
+// maxAcceptLanguages bounds how many Accept-Language ranges are considered
+const maxAcceptLanguages = 8
+
+//go:embed locales/*.json
+var builtinLocales embed.FS
+
+// messageCatalog translates English API messages, keyed by the message or its format string, into
+// one language. Translations keep the format verbs of their key in the same order.
+type messageCatalog struct {
+	Language string `json:"language"`
+	Name     string `json:"name"`
+	// Fallback is tried for messages this catalog lacks, before the default language
+	Fallback string            `json:"fallback,omitempty"`
+	Messages map[string]string `json:"messages"`
+}
+
+// localizer picks the language of error messages from Accept-Language
+type localizer struct {
+	catalogs        map[string]*messageCatalog
+	defaultLanguage string
+}
+
+var messages = &localizer{catalogs: map[string]*messageCatalog{}, defaultLanguage: sourceLanguage}
+
+// initI18n loads the built-in catalogs and any catalogs in I18N_CATALOG_DIR, which add languages
+// or override built-in translations message by message
+func initI18n() {
+	l := &localizer{catalogs: map[string]*messageCatalog{}, defaultLanguage: strings.ToLower(getEnv("I18N_DEFAULT_LANGUAGE", sourceLanguage))}
+	if err := l.load(builtinLocales, "locales"); err != nil {
+		panic(fmt.Errorf("failed to load built-in message catalogs: %w", err))
+	}
+	if dir := getEnv("I18N_CATALOG_DIR", ""); dir != "" {
+		if err := l.load(os.DirFS(dir), "."); err != nil {
+			panic(fmt.Errorf("failed to load message catalogs from %s: %w", dir, err))
+		}
+	}
+	if _, ok := l.catalogs[l.defaultLanguage]; !ok && l.defaultLanguage != sourceLanguage {
+		panic(fmt.Errorf("I18N_DEFAULT_LANGUAGE %q has no message catalog", l.defaultLanguage))
+	}
+
+	messages = l
+	log.Printf("🌐 API messages available in %s, default %s", strings.Join(l.languages(), ", "), l.defaultLanguage)
+}
+
+func (l *localizer) load(fsys fs.FS, dir string) error {
+	paths, err := fs.Glob(fsys, path.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, file := range paths {
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return err
+		}
+		var catalog messageCatalog
+		if err := json.Unmarshal(data, &catalog); err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		catalog.Language = strings.ToLower(catalog.Language)
+		if catalog.Language == "" || catalog.Language == sourceLanguage {
+			return fmt.Errorf("%s: language must be set to a language other than %s", file, sourceLanguage)
+		}
+		if catalog.Messages == nil {
+			catalog.Messages = map[string]string{}
+		}
+		catalog.Fallback = strings.ToLower(catalog.Fallback)
+		for key, text := range catalog.Messages {
+			if formatVerbs(key) != formatVerbs(text) {
+				return fmt.Errorf("%s: translation of %q must keep its format verbs", file, key)
+			}
+		}
+
+		existing, ok := l.catalogs[catalog.Language]
+		if !ok {
+			l.catalogs[catalog.Language] = &catalog
+			continue
+		}
+		for key, text := range catalog.Messages {
+			existing.Messages[key] = text
+		}
+		if catalog.Fallback != "" {
+			existing.Fallback = catalog.Fallback
+		}
+	}
+	return nil
+}
+
+func (l *localizer) languages() []string {
+	languages := []string{sourceLanguage}
+	for language := range l.catalogs {
+		languages = append(languages, language)
+	}
+	sort.Strings(languages[1:])
+	return languages
+}
+
+// negotiate returns the languages to try for a request, most preferred first. Each
+// Accept-Language range is tried as given and then by its primary subtag, followed by its
+// catalog's fallback; the default language and English come last. Ranges with q=0 are skipped.
+func (l *localizer) negotiate(acceptLanguage string) []string {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+	var ranges []weighted
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if q > 0 {
+			ranges = append(ranges, weighted{tag, q})
+		}
+		if len(ranges) == maxAcceptLanguages {
+			break
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].q > ranges[j].q })
+
+	var languages []string
+	seen := map[string]bool{}
+	var add func(string)
+	add = func(language string) {
+		if seen[language] {
+			return
+		}
+		seen[language] = true
+		if catalog, ok := l.catalogs[language]; ok {
+			languages = append(languages, language)
+			if catalog.Fallback != "" {
+				add(catalog.Fallback)
+			}
+		} else if language == sourceLanguage {
+			languages = append(languages, language)
+		}
+	}
+	for _, r := range ranges {
+		add(r.tag)
+		if primary, _, ok := strings.Cut(r.tag, "-"); ok {
+			add(primary)
+		}
+	}
+	add(l.defaultLanguage)
+	add(sourceLanguage)
+	return languages
+}
+
+// translate formats an English message in the first of languages that has it, returning the text
+// and the language used
+func (l *localizer) translate(languages []string, format string, args ...interface{}) (string, string) {
+	for _, language := range languages {
+		if language == sourceLanguage {
+			break
+		}
+		if text, ok := l.catalogs[language].Messages[format]; ok {
+			return sprintf(text, args), language
+		}
+	}
+	return sprintf(format, args), sourceLanguage
+}
+
+func sprintf(format string, args []interface{}) string {
+	if len(args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// formatVerbs lists the format verbs in a message, ignoring escaped percent signs
+func formatVerbs(format string) string {
+	var verbs strings.Builder
+	for i := 0; i < len(format)-1; i++ {
+		if format[i] != '%' {
+			continue
+		}
+		i++
+		if format[i] != '%' {
+			verbs.WriteByte(format[i])
+		}
+	}
+	return verbs.String()
+}
+
+// requestLanguages negotiates the request's languages once and remembers them
+func requestLanguages(c *gin.Context) []string {
+	if languages, ok := c.Get("languages"); ok {
+		return languages.([]string)
+	}
+	languages := messages.negotiate(c.GetHeader("Accept-Language"))
+	c.Set("languages", languages)
+	return languages
+}
+
+// localize translates a response message for the caller, marking the response with the language
+// used. Messages without a translation, such as chaincode errors, are returned in English.
+func localize(c *gin.Context, format string, args ...interface{}) string {
+	text, language := messages.translate(requestLanguages(c), format, args...)
+	c.Header("Content-Language", language)
+	c.Writer.Header().Add("Vary", "Accept-Language")
+	return text
+}
+
+// localizeFields translates field errors without touching the response headers
+func localizeFields(c *gin.Context, errs ValidationErrors) ValidationErrors {
+	languages := requestLanguages(c)
+	localized := make(ValidationErrors, len(errs))
+	for i, fe := range errs {
+		localized[i] = fe
+		if fe.format != "" {
+			localized[i].Message, _ = messages.translate(languages, fe.format, fe.args...)
+		} else {
+			localized[i].Message, _ = messages.translate(languages, fe.Message)
+		}
+	}
+	return localized
+}
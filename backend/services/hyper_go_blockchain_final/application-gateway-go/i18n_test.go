@@ -0,0 +1,123 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMessageCatalogs(t *testing.T) {
+	l := &localizer{catalogs: map[string]*messageCatalog{}, defaultLanguage: sourceLanguage}
+	if err := l.load(builtinLocales, "locales"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every translated message must still be sent by the gateway, or the catalog has gone stale
+	files, _ := filepath.Glob("*.go")
+	var source strings.Builder
+	for _, file := range files {
+		if !strings.HasSuffix(file, "_test.go") {
+			data, _ := os.ReadFile(file)
+			source.Write(data)
+		}
+	}
+	hindi := l.catalogs["hi"]
+	for key := range hindi.Messages {
+		if !strings.Contains(source.String(), strconv.Quote(key)) {
+			t.Errorf("catalog message %q is not used by the gateway", key)
+		}
+	}
+	for _, language := range []string{"as", "bn", "ne"} {
+		catalog := l.catalogs[language]
+		if catalog == nil || len(catalog.Messages) != len(hindi.Messages) {
+			t.Errorf("%s catalog does not cover the same messages as hi", language)
+			continue
+		}
+		for key := range hindi.Messages {
+			if _, ok := catalog.Messages[key]; !ok {
+				t.Errorf("%s catalog is missing %q", language, key)
+			}
+		}
+	}
+
+	bad := fstest.MapFS{"mni.json": {Data: []byte(`{"language":"mni","messages":{"must be between 1 and %d":"must be between 1 and 100"}}`)}}
+	if err := l.load(bad, "."); err == nil {
+		t.Error("expected a translation that drops a format verb to be rejected")
+	}
+}
+
+func TestLanguageNegotiation(t *testing.T) {
+	messages = &localizer{catalogs: map[string]*messageCatalog{}, defaultLanguage: sourceLanguage}
+	defer func() { messages = &localizer{catalogs: map[string]*messageCatalog{}, defaultLanguage: sourceLanguage} }()
+	if err := messages.load(builtinLocales, "locales"); err != nil {
+		t.Fatal(err)
+	}
+	// A partial Bodo catalog added by the deployment falls back to Assamese
+	extra := fstest.MapFS{"brx.json": {Data: []byte(`{"language":"brx","fallback":"as","messages":{"is required":"गोनांथार"}}`)}}
+	if err := messages.load(extra, "."); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]string{
+		"":                            "en",
+		"as-IN, hi;q=0.8, en;q=0.5":   "as,hi,en",
+		"en-GB;q=0.9, ne":             "ne,en",
+		"fr, *":                       "en",
+		"hi;q=0, bn-IN;q=0.4":         "bn,en",
+		"brx":                         "brx,as,en",
+		"hi;q=abc, HI-in;q=0.2, as":   "as,hi,en",
+		"kha, lus;q=0.9, mni;q=0.8":   "en",
+		"bn-bd;q=0.9, as-in;q=0.95,,": "as,bn,en",
+	}
+	for header, want := range cases {
+		if got := strings.Join(messages.negotiate(header), ","); got != want {
+			t.Errorf("Accept-Language %q: expected %s, got %s", header, want, got)
+		}
+	}
+
+	// Messages missing from a catalog fall through to the next language
+	languages := messages.negotiate("brx")
+	if text, language := messages.translate(languages, "is required"); language != "brx" || text != "गोनांथार" {
+		t.Errorf("expected the Bodo translation, got %q in %s", text, language)
+	}
+	if _, language := messages.translate(languages, "must not be empty"); language != "as" {
+		t.Errorf("expected the Assamese fallback, got %s", language)
+	}
+	if text, language := messages.translate(languages, "the incident inc-1 does not exist"); language != "en" || text != "the incident inc-1 does not exist" {
+		t.Errorf("expected untranslated messages in English, got %q in %s", text, language)
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/incident", func(c *gin.Context) {
+		var req CreateIncidentRequest
+		if bindRequest(c, &req) {
+			c.Status(http.StatusOK)
+		}
+	})
+	body := `{"incidentID":"inc-1","incidentSummaryHash":"` + strings.Repeat("a", 64) + `","reporter":"officer","severity":"extreme"}`
+	req := httptest.NewRequest(http.MethodPost, "/incident", strings.NewReader(body))
+	req.Header.Set("Accept-Language", "hi-IN,hi;q=0.9,en;q=0.8")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	var response APIResponse
+	json.Unmarshal(w.Body.Bytes(), &response)
+	if w.Code != http.StatusBadRequest || w.Header().Get("Content-Language") != "hi" || w.Header().Get("Vary") != "Accept-Language" {
+		t.Fatalf("unexpected response %d %v", w.Code, w.Header())
+	}
+	if response.Error.Code != errCodeValidation || response.Error.Message != "अनुरोध का सत्यापन विफल रहा" {
+		t.Errorf("expected the error code unchanged and the message in Hindi, got %+v", response.Error)
+	}
+	if len(response.Error.Fields) != 1 || response.Error.Fields[0].Message != "इनमें से एक होना चाहिए: low, medium, high, critical" {
+		t.Errorf("expected the field error in Hindi with its arguments, got %+v", response.Error.Fields)
+	}
+}
@@ -0,0 +1,315 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	idempotencyHeader = "Idempotency-Key"
+	replayedHeader    = "Idempotent-Replayed"
+	idempotencyPrefix = "sih:idem:"
+
+	errCodeIdempotencyInProgress = "IDEMPOTENCY_IN_PROGRESS"
+	errCodeIdempotencyMismatch   = "IDEMPOTENCY_KEY_REUSED"
+
+	maxIdempotencyKeyLength = 255
+)
+
+// idempotencyRecord is what a key maps to: a claim while the first request runs, then its response
+type idempotencyRecord struct {
+	Pending     bool              `json:"pending,omitempty"`
+	Fingerprint string            `json:"fingerprint,omitempty"`
+	Status      int               `json:"status,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Body        []byte            `json:"body,omitempty"`
+}
+
+// idempotencyStore keeps records in Redis when the document cache is configured, so every gateway
+// instance sees the same keys, and in memory otherwise
+type idempotencyStore interface {
+	// claim stores rec only if key is unused, reporting whether it did
+	claim(ctx context.Context, key string, rec idempotencyRecord, ttl time.Duration) (bool, error)
+	get(ctx context.Context, key string) (idempotencyRecord, error)
+	put(ctx context.Context, key string, rec idempotencyRecord, ttl time.Duration) error
+	release(ctx context.Context, key string) error
+}
+
+// idempotencyMiddleware replays the stored response when a POST is retried with the same
+// Idempotency-Key, so a client that timed out can retry without creating a second record. Keys are
+// scoped to the caller; reusing one for a different request is rejected. Server errors are not
+// stored, so those requests can be retried for real.
+func idempotencyMiddleware() gin.HandlerFunc {
+	if !getEnvBool("IDEMPOTENCY_ENABLED", true) {
+		return func(c *gin.Context) { c.Next() }
+	}
+
+	var store idempotencyStore = newMemoryIdempotencyStore()
+	backend := "memory"
+	if documentCache != nil {
+		store, backend = redisIdempotencyStore{documentCache}, "Redis"
+	}
+	ttl := getEnvDuration("IDEMPOTENCY_TTL", 24*time.Hour)
+	lockTTL := getEnvDuration("IDEMPOTENCY_LOCK_TTL", 5*time.Minute)
+	maxBody := getEnvInt("IDEMPOTENCY_MAX_RESPONSE_BYTES", 1<<20)
+	log.Printf("🔁 Keeping Idempotency-Key responses for %s in %s", ttl, backend)
+
+	return func(c *gin.Context) {
+		key := c.GetHeader(idempotencyHeader)
+		if c.Request.Method != http.MethodPost || key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			respondValidationErrors(c, ValidationErrors{{Field: idempotencyHeader, Message: "must be at most 255 characters"}})
+			c.Abort()
+			return
+		}
+
+		ctx := c.Request.Context()
+		storeKey := idempotencyStoreKey(rateLimitKey(c), key)
+		claimed, err := store.claim(ctx, storeKey, idempotencyRecord{Pending: true}, lockTTL)
+		if err != nil {
+			// Like the document cache, an unavailable store degrades to processing the request normally
+			logWithContext(ctx, "Idempotency store unavailable, processing request without it: %v", err)
+			c.Next()
+			return
+		}
+		if !claimed {
+			replayIdempotent(c, store, storeKey)
+			return
+		}
+
+		fingerprint := newRequestFingerprint(c)
+		c.Request.Body = readCloser{io.TeeReader(c.Request.Body, fingerprint.hash), c.Request.Body}
+		before := c.Writer.Header().Clone()
+		recorder := &responseRecorder{ResponseWriter: c.Writer, limit: maxBody}
+		c.Writer = recorder
+
+		c.Next()
+
+		// Hash whatever the handler left unread so retries are compared against the whole body
+		io.Copy(io.Discard, c.Request.Body)
+		status := recorder.Status()
+		if status >= http.StatusInternalServerError || recorder.truncated {
+			if err := store.release(context.WithoutCancel(ctx), storeKey); err != nil {
+				logWithContext(ctx, "Failed to release idempotency key: %v", err)
+			}
+			return
+		}
+
+		rec := idempotencyRecord{Fingerprint: fingerprint.sum(), Status: status, Headers: map[string]string{}, Body: recorder.body.Bytes()}
+		for name, values := range recorder.Header() {
+			if len(values) > 0 && before.Get(name) != values[0] {
+				rec.Headers[name] = values[0]
+			}
+		}
+		if err := store.put(context.WithoutCancel(ctx), storeKey, rec, ttl); err != nil {
+			logWithContext(ctx, "Failed to store idempotent response: %v", err)
+		}
+	}
+}
+
+// replayIdempotent answers a retry from the stored record of the first request
+func replayIdempotent(c *gin.Context, store idempotencyStore, storeKey string) {
+	defer c.Abort()
+	rec, err := store.get(c.Request.Context(), storeKey)
+	if err != nil || rec.Pending {
+		if err != nil && !errors.Is(err, errRedisNil) {
+			logWithContext(c.Request.Context(), "Failed to read idempotency record: %v", err)
+		}
+		c.Header("Retry-After", "1")
+		respondError(c, http.StatusConflict, errCodeIdempotencyInProgress, "A request with this Idempotency-Key is still being processed")
+		return
+	}
+
+	fingerprint := newRequestFingerprint(c)
+	io.Copy(fingerprint.hash, c.Request.Body)
+	if fingerprint.sum() != rec.Fingerprint {
+		respondError(c, http.StatusUnprocessableEntity, errCodeIdempotencyMismatch, "Idempotency-Key was already used for a different request")
+		return
+	}
+
+	for name, value := range rec.Headers {
+		c.Header(name, value)
+	}
+	c.Header(replayedHeader, "true")
+	c.Data(rec.Status, rec.Headers["Content-Type"], rec.Body)
+}
+
+// idempotencyStoreKey scopes a client's key to its caller, hashing both so API keys are not stored
+func idempotencyStoreKey(caller, key string) string {
+	sum := sha256.Sum256([]byte(caller + "\x00" + key))
+	return idempotencyPrefix + hex.EncodeToString(sum[:])
+}
+
+// requestFingerprint hashes what makes two requests the same: route, target and body
+type requestFingerprint struct {
+	hash hash.Hash
+}
+
+func newRequestFingerprint(c *gin.Context) requestFingerprint {
+	h := sha256.New()
+	io.WriteString(h, c.Request.Method+" "+c.Request.URL.Path+"\x00")
+	if target := targetFromContext(c.Request.Context()); target != nil {
+		io.WriteString(h, target.Name)
+	}
+	h.Write([]byte{0})
+	return requestFingerprint{hash: h}
+}
+
+func (f requestFingerprint) sum() string {
+	return hex.EncodeToString(f.hash.Sum(nil))
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// responseRecorder copies the response body, up to limit bytes, as it is written to the client
+type responseRecorder struct {
+	gin.ResponseWriter
+	body      bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (w *responseRecorder) Write(data []byte) (int, error) {
+	w.record(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	w.record([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *responseRecorder) record(data []byte) {
+	if w.body.Len()+len(data) > w.limit {
+		w.truncated = true
+		return
+	}
+	w.body.Write(data)
+}
+
+type redisIdempotencyStore struct {
+	redis *redisClient
+}
+
+func (s redisIdempotencyStore) claim(ctx context.Context, key string, rec idempotencyRecord, ttl time.Duration) (bool, error) {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return false, err
+	}
+	reply, err := s.redis.Do(ctx, "SET", key, string(data), "NX", "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	if errors.Is(err, errRedisNil) {
+		return false, nil
+	}
+	return reply != nil && err == nil, err
+}
+
+func (s redisIdempotencyStore) get(ctx context.Context, key string) (idempotencyRecord, error) {
+	var rec idempotencyRecord
+	data, err := s.redis.Get(ctx, key)
+	if err != nil {
+		return rec, err
+	}
+	return rec, json.Unmarshal(data, &rec)
+}
+
+func (s redisIdempotencyStore) put(ctx context.Context, key string, rec idempotencyRecord, ttl time.Duration) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return s.redis.Set(ctx, key, data, ttl)
+}
+
+func (s redisIdempotencyStore) release(ctx context.Context, key string) error {
+	return s.redis.Del(ctx, key)
+}
+
+// memoryIdempotencyStore serves a single gateway instance; expired records are swept at most once
+// a minute, on claims
+type memoryIdempotencyStore struct {
+	mu        sync.Mutex
+	records   map[string]memoryIdempotencyEntry
+	lastSweep time.Time
+}
+
+type memoryIdempotencyEntry struct {
+	rec     idempotencyRecord
+	expires time.Time
+}
+
+func newMemoryIdempotencyStore() *memoryIdempotencyStore {
+	return &memoryIdempotencyStore{records: map[string]memoryIdempotencyEntry{}}
+}
+
+func (s *memoryIdempotencyStore) claim(_ context.Context, key string, rec idempotencyRecord, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if entry, ok := s.records[key]; ok && now.Before(entry.expires) {
+		return false, nil
+	}
+	s.sweep(now)
+	s.records[key] = memoryIdempotencyEntry{rec: rec, expires: now.Add(ttl)}
+	return true, nil
+}
+
+func (s *memoryIdempotencyStore) get(_ context.Context, key string) (idempotencyRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.records[key]
+	if !ok || !time.Now().Before(entry.expires) {
+		return idempotencyRecord{}, errRedisNil
+	}
+	return entry.rec, nil
+}
+
+func (s *memoryIdempotencyStore) put(_ context.Context, key string, rec idempotencyRecord, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[key] = memoryIdempotencyEntry{rec: rec, expires: time.Now().Add(ttl)}
+	return nil
+}
+
+func (s *memoryIdempotencyStore) release(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, key)
+	return nil
+}
+
+func (s *memoryIdempotencyStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+	s.lastSweep = now
+	for key, entry := range s.records {
+		if !now.Before(entry.expires) {
+			delete(s.records, key)
+		}
+	}
+}
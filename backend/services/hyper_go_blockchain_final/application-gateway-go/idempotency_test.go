@@ -0,0 +1,54 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestIdempotencyMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	calls := 0
+	r := gin.New()
+	r.POST("/incident/", idempotencyMiddleware(), func(c *gin.Context) {
+		calls++
+		c.Header("X-Transaction-ID", "tx-1")
+		if c.GetHeader("X-Fail") != "" {
+			respondError(c, http.StatusServiceUnavailable, errCodeUnavailable, "peer unavailable")
+			return
+		}
+		respondData(c, http.StatusCreated, gin.H{"id": "incident-1"})
+	})
+
+	send := func(key, body string, headers ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/incident/", strings.NewReader(body))
+		req.Header.Set(idempotencyHeader, key)
+		for i := 0; i+1 < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	first := send("retry-1", `{"title":"lost passport"}`)
+	retry := send("retry-1", `{"title":"lost passport"}`)
+	if calls != 1 || first.Code != http.StatusCreated || retry.Code != http.StatusCreated {
+		t.Fatalf("expected one handler call and two 201s, got %d calls, %d and %d", calls, first.Code, retry.Code)
+	}
+	if retry.Body.String() != first.Body.String() || retry.Header().Get("X-Transaction-ID") != "tx-1" || retry.Header().Get(replayedHeader) != "true" {
+		t.Errorf("retry was not a replay of the first response: %s %v", retry.Body, retry.Header())
+	}
+
+	if w := send("retry-1", `{"title":"stolen phone"}`); w.Code != http.StatusUnprocessableEntity || calls != 1 {
+		t.Errorf("expected a reused key with a different body to be rejected, got %d", w.Code)
+	}
+
+	send("retry-2", `{}`, "X-Fail", "1")
+	if w := send("retry-2", `{}`); w.Code != http.StatusCreated || calls != 3 {
+		t.Errorf("expected a server error not to be stored, got %d after %d calls", w.Code, calls)
+	}
+}
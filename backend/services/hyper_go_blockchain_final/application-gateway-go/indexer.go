@@ -0,0 +1,925 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+// indexSchema creates the relational projections of ledger state. Statements are idempotent so
+// the schema is applied on every start.
+const indexSchema = `
+CREATE TABLE IF NOT EXISTS index_checkpoints (
+	name         TEXT PRIMARY KEY,
+	block_number BIGINT NOT NULL,
+	tx_id        TEXT NOT NULL,
+	updated_at   TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS dids (
+	digital_id   TEXT PRIMARY KEY,
+	consent_hash TEXT NOT NULL,
+	issuer       TEXT NOT NULL,
+	issued_at    TIMESTAMPTZ,
+	expires_at   TIMESTAMPTZ,
+	tx_id        TEXT NOT NULL,
+	block_number BIGINT NOT NULL,
+	deleted_at   TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS dids_expires_at ON dids (expires_at) WHERE deleted_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS incidents (
+	incident_id           TEXT PRIMARY KEY,
+	incident_summary_hash TEXT NOT NULL,
+	reporter              TEXT NOT NULL,
+	status                TEXT NOT NULL DEFAULT '',
+	severity              TEXT NOT NULL DEFAULT '',
+	created_at            TIMESTAMPTZ NOT NULL,
+	acknowledged_at       TIMESTAMPTZ,
+	resolved_at           TIMESTAMPTZ,
+	tx_id                 TEXT NOT NULL,
+	block_number          BIGINT NOT NULL,
+	deleted_at            TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS incidents_created_at ON incidents (created_at DESC, incident_id DESC) WHERE deleted_at IS NULL;
+ALTER TABLE incidents ADD COLUMN IF NOT EXISTS category TEXT NOT NULL DEFAULT '';
+ALTER TABLE incidents ADD COLUMN IF NOT EXISTS subject_id TEXT NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS incidents_acknowledged_at ON incidents (acknowledged_at) WHERE deleted_at IS NULL;
+ALTER TABLE incidents ADD COLUMN IF NOT EXISTS geohash TEXT NOT NULL DEFAULT '';
+ALTER TABLE incidents ADD COLUMN IF NOT EXISTS latitude DOUBLE PRECISION;
+ALTER TABLE incidents ADD COLUMN IF NOT EXISTS longitude DOUBLE PRECISION;
+CREATE INDEX IF NOT EXISTS incidents_location ON incidents (latitude, longitude) WHERE geohash <> '' AND deleted_at IS NULL;
+ALTER TABLE incidents ADD COLUMN IF NOT EXISTS owner_org TEXT NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS incidents_owner ON incidents (owner_org, created_at DESC) WHERE deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS incidents_subject ON incidents (subject_id, created_at DESC) WHERE subject_id <> '' AND deleted_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS sos_alerts (
+	alert_id     TEXT PRIMARY KEY,
+	digital_id   TEXT NOT NULL,
+	police_unit  TEXT NOT NULL DEFAULT '',
+	geohash      TEXT NOT NULL DEFAULT '',
+	latitude     DOUBLE PRECISION,
+	longitude    DOUBLE PRECISION,
+	raised_at    TIMESTAMPTZ NOT NULL,
+	tx_id        TEXT NOT NULL,
+	block_number BIGINT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS sos_alerts_location ON sos_alerts (latitude, longitude) WHERE geohash <> '';
+CREATE INDEX IF NOT EXISTS sos_alerts_raised_at ON sos_alerts (raised_at);
+ALTER TABLE sos_alerts ADD COLUMN IF NOT EXISTS owner_org TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS evidence (
+	evidence_id   TEXT PRIMARY KEY,
+	incident_id   TEXT NOT NULL,
+	evidence_hash TEXT NOT NULL,
+	media_type    TEXT NOT NULL,
+	uploaded_by   TEXT NOT NULL,
+	cid           TEXT NOT NULL DEFAULT '',
+	created_at    TIMESTAMPTZ NOT NULL,
+	tx_id         TEXT NOT NULL,
+	block_number  BIGINT NOT NULL,
+	deleted_at    TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS evidence_incident ON evidence (incident_id, created_at) WHERE deleted_at IS NULL;
+ALTER TABLE evidence ADD COLUMN IF NOT EXISTS owner_org TEXT NOT NULL DEFAULT '';
+ALTER TABLE evidence ADD COLUMN IF NOT EXISTS encryption_key_ref TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS audits (
+	tx_id      TEXT NOT NULL,
+	target_id  TEXT NOT NULL,
+	action     TEXT NOT NULL,
+	actor      TEXT NOT NULL,
+	audit_hash TEXT NOT NULL,
+	timestamp  TIMESTAMPTZ NOT NULL,
+	PRIMARY KEY (tx_id, target_id, action)
+);
+ALTER TABLE audits ADD COLUMN IF NOT EXISTS submitter TEXT NOT NULL DEFAULT '';
+ALTER TABLE audits ADD COLUMN IF NOT EXISTS route TEXT NOT NULL DEFAULT '';
+ALTER TABLE audits ADD COLUMN IF NOT EXISTS request_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE audits ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT '';
+-- A batch of API audit entries shares a transaction and may act on one target more than once
+ALTER TABLE audits DROP CONSTRAINT IF EXISTS audits_pkey;
+CREATE UNIQUE INDEX IF NOT EXISTS audits_entry ON audits (tx_id, target_id, action, request_id);
+CREATE INDEX IF NOT EXISTS audits_timestamp ON audits (timestamp DESC);
+CREATE INDEX IF NOT EXISTS audits_target ON audits (target_id, timestamp);
+
+CREATE TABLE IF NOT EXISTS incident_archives (
+	archive_id   TEXT PRIMARY KEY,
+	incident_id  TEXT NOT NULL,
+	digest       TEXT NOT NULL,
+	object_key   TEXT NOT NULL,
+	archived_at  TIMESTAMPTZ NOT NULL,
+	owner_org    TEXT NOT NULL DEFAULT '',
+	tx_id        TEXT NOT NULL,
+	block_number BIGINT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS incident_archives_incident ON incident_archives (incident_id);
+
+CREATE TABLE IF NOT EXISTS assignments (
+	assignment_id   TEXT PRIMARY KEY,
+	subject_type    TEXT NOT NULL,
+	subject_id      TEXT NOT NULL,
+	unit_id         TEXT NOT NULL,
+	attempt         INTEGER NOT NULL,
+	status          TEXT NOT NULL,
+	assigned_at     TIMESTAMPTZ NOT NULL,
+	ack_deadline    TIMESTAMPTZ,
+	acknowledged_at TIMESTAMPTZ,
+	owner_org       TEXT NOT NULL DEFAULT '',
+	tx_id           TEXT NOT NULL,
+	block_number    BIGINT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS assignments_subject ON assignments (subject_id);
+
+-- sync_seq numbers every write to the tables patrol apps sync, so a delta sync resumes after the
+-- last change it returned. The advisory lock holds each writing transaction until the previous one
+-- commits, so numbers become visible in order even with more than one indexer.
+CREATE SEQUENCE IF NOT EXISTS sync_seq;
+CREATE OR REPLACE FUNCTION stamp_sync_seq() RETURNS trigger AS $$
+BEGIN
+	PERFORM pg_advisory_xact_lock(hashtext('sync_seq'));
+	NEW.sync_seq := nextval('sync_seq');
+	RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+ALTER TABLE incidents ADD COLUMN IF NOT EXISTS sync_seq BIGINT;
+ALTER TABLE evidence ADD COLUMN IF NOT EXISTS sync_seq BIGINT;
+ALTER TABLE assignments ADD COLUMN IF NOT EXISTS sync_seq BIGINT;
+DROP TRIGGER IF EXISTS incidents_sync_seq ON incidents;
+CREATE TRIGGER incidents_sync_seq BEFORE INSERT OR UPDATE ON incidents FOR EACH ROW EXECUTE FUNCTION stamp_sync_seq();
+DROP TRIGGER IF EXISTS evidence_sync_seq ON evidence;
+CREATE TRIGGER evidence_sync_seq BEFORE INSERT OR UPDATE ON evidence FOR EACH ROW EXECUTE FUNCTION stamp_sync_seq();
+DROP TRIGGER IF EXISTS assignments_sync_seq ON assignments;
+CREATE TRIGGER assignments_sync_seq BEFORE INSERT OR UPDATE ON assignments FOR EACH ROW EXECUTE FUNCTION stamp_sync_seq();
+-- Rows projected before sync_seq existed are numbered once; the trigger assigns the value
+UPDATE incidents SET sync_seq = 0 WHERE sync_seq IS NULL;
+UPDATE evidence SET sync_seq = 0 WHERE sync_seq IS NULL;
+CREATE INDEX IF NOT EXISTS incidents_sync_seq ON incidents (sync_seq);
+CREATE INDEX IF NOT EXISTS evidence_sync_seq ON evidence (sync_seq);
+CREATE INDEX IF NOT EXISTS assignments_sync_seq ON assignments (sync_seq);
+`
+
+// offchainIndex projects chaincode events into PostgreSQL and serves list, search and statistics
+// queries from the projections, keeping heavy reads off the peers
+type offchainIndex struct {
+	db             *pgClient
+	checkpointName string
+	lastBlock      atomic.Uint64
+	lastEventAt    atomic.Int64
+	running        atomic.Bool
+	// external is set when sih-indexer maintains the projections from blocks and the gateway only
+	// reads them
+	external bool
+}
+
+var offchain *offchainIndex
+
+// initOffchainIndex connects to INDEX_DATABASE_URL and applies the schema. Without it every read
+// goes to the ledger as before.
+func initOffchainIndex() {
+	rawURL := getEnv("INDEX_DATABASE_URL", "")
+	if rawURL == "" {
+		log.Println("🗂️  INDEX_DATABASE_URL not set, off-chain index disabled")
+		return
+	}
+
+	db := openIndexDatabase(rawURL)
+	offchain = &offchainIndex{db: db, checkpointName: defaultTarget.Channel + "/" + defaultTarget.Chaincode}
+	switch consumer := getEnv("INDEX_CONSUMER", "gateway"); consumer {
+	case "gateway":
+	case "indexer":
+		offchain.external = true
+	default:
+		panic(fmt.Errorf("INDEX_CONSUMER must be gateway or indexer, not %q", consumer))
+	}
+	dashboardStats.source = indexStatsSource{index: offchain}
+	log.Printf("🗂️  Off-chain index enabled at %s, maintained by the %s", db.addr, getEnv("INDEX_CONSUMER", "gateway"))
+}
+
+// openIndexDatabase connects to the index database and applies the schema
+func openIndexDatabase(rawURL string) *pgClient {
+	db, err := newPGClient(rawURL, getEnvInt("INDEX_POOL_SIZE", 8), getEnvDuration("INDEX_TIMEOUT", 10*time.Second))
+	if err != nil {
+		panic(fmt.Errorf("failed to configure off-chain index: %w", err))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := db.ExecScript(ctx, indexSchema); err != nil {
+		panic(fmt.Errorf("failed to migrate off-chain index: %w", err))
+	}
+	return db
+}
+
+// loadCheckpoint returns the position after the last event applied, or nil to start from genesis
+func (ix *offchainIndex) loadCheckpoint(ctx context.Context) (*client.InMemoryCheckpointer, error) {
+	rows, err := ix.db.Query(ctx, `SELECT block_number, tx_id FROM index_checkpoints WHERE name = $1`, ix.checkpointName)
+	if err != nil || len(rows) == 0 {
+		return nil, err
+	}
+	checkpointer := new(client.InMemoryCheckpointer)
+	checkpointer.CheckpointTransaction(uint64(rows[0].Int(0)), rows[0].String(1))
+	ix.lastBlock.Store(uint64(rows[0].Int(0)))
+	return checkpointer, nil
+}
+
+// run consumes the target's chaincode events until ctx is cancelled, reconnecting from the stored
+// checkpoint whenever the stream ends so no event is skipped or applied twice
+func (ix *offchainIndex) run(ctx context.Context, target *fabricTarget) {
+	ctx = withTarget(ctx, target)
+	ix.running.Store(true)
+	defer ix.running.Store(false)
+
+	backoff := time.Second
+	for ctx.Err() == nil {
+		err := ix.consume(ctx, target)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("🗂️  Off-chain indexer stopped, retrying in %s: %v", backoff, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, time.Minute)
+	}
+}
+
+func (ix *offchainIndex) consume(ctx context.Context, target *fabricTarget) error {
+	checkpoint, err := ix.loadCheckpoint(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load checkpoint: %w", err)
+	}
+	option := client.WithStartBlock(0)
+	if checkpoint != nil {
+		option = client.WithCheckpoint(checkpoint)
+	}
+
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	events, err := target.network.ChaincodeEvents(streamCtx, target.Chaincode, option)
+	if err != nil {
+		return err
+	}
+	log.Printf("🗂️  Off-chain indexer consuming events from block %d", ix.lastBlock.Load())
+
+	for event := range events {
+		if err := ix.apply(ctx, event); err != nil {
+			return fmt.Errorf("failed to index %s in block %d: %w", event.EventName, event.BlockNumber, err)
+		}
+	}
+	return errors.New("event stream closed")
+}
+
+// apply projects one event and advances the checkpoint in a single database transaction. The
+// target's audit entries are re-read from the ledger, since audits do not raise events of their own;
+// only API audit batches carry their entries in the event.
+func (ix *offchainIndex) apply(ctx context.Context, event *client.ChaincodeEvent) error {
+	var ids struct {
+		DigitalID  string `json:"digital_id"`
+		IncidentID string `json:"incident_id"`
+		EvidenceID string `json:"evidence_id"`
+		AlertID    string `json:"alert_id"`
+	}
+	json.Unmarshal(event.Payload, &ids)
+
+	var target string
+	switch {
+	case strings.HasSuffix(event.EventName, "DID"):
+		target = ids.DigitalID
+	case strings.HasSuffix(event.EventName, "Evidence"):
+		target = ids.EvidenceID
+	case strings.HasSuffix(event.EventName, "SOS"):
+		target = ids.AlertID
+	default:
+		target = ids.IncidentID
+	}
+	var audits []AuditDocument
+	if target != "" {
+		var err error
+		if audits, err = auditsByTargetFromLedger(ctx, target); err != nil {
+			return fmt.Errorf("failed to read audits for %s: %w", target, err)
+		}
+	}
+
+	err := ix.db.Tx(ctx, func(q pgQuerier) error {
+		if err := projectEvent(ctx, q, event); err != nil {
+			return err
+		}
+		for _, audit := range audits {
+			if err := insertAudit(ctx, q, audit); err != nil {
+				return err
+			}
+		}
+		_, err := q.Exec(ctx, `INSERT INTO index_checkpoints (name, block_number, tx_id, updated_at) VALUES ($1, $2, $3, now())
+			ON CONFLICT (name) DO UPDATE SET block_number = EXCLUDED.block_number, tx_id = EXCLUDED.tx_id, updated_at = now()`,
+			ix.checkpointName, event.BlockNumber, event.TransactionID)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	ix.lastBlock.Store(event.BlockNumber)
+	ix.lastEventAt.Store(time.Now().Unix())
+	return nil
+}
+
+func insertAudit(ctx context.Context, q pgQuerier, audit AuditDocument) error {
+	_, err := q.Exec(ctx, `INSERT INTO audits (tx_id, target_id, action, actor, audit_hash, timestamp, submitter, route, request_id, status)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) ON CONFLICT DO NOTHING`,
+		audit.TxID, audit.TargetID, audit.Action, audit.Actor, audit.AuditHash, audit.Timestamp, audit.Submitter,
+		audit.Route, audit.RequestID, audit.Status)
+	return err
+}
+
+// indexPosition is the transaction and block that committed a projected document
+type indexPosition struct {
+	txID  string
+	block uint64
+}
+
+func eventPosition(event *client.ChaincodeEvent) indexPosition {
+	return indexPosition{txID: event.TransactionID, block: event.BlockNumber}
+}
+
+func upsertDID(ctx context.Context, q pgQuerier, did DIDDocument, pos indexPosition) error {
+	_, err := q.Exec(ctx, `INSERT INTO dids (digital_id, consent_hash, issuer, issued_at, expires_at, tx_id, block_number)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (digital_id) DO UPDATE SET consent_hash = EXCLUDED.consent_hash, issuer = EXCLUDED.issuer,
+			issued_at = EXCLUDED.issued_at, expires_at = EXCLUDED.expires_at, tx_id = EXCLUDED.tx_id,
+			block_number = EXCLUDED.block_number, deleted_at = NULL`,
+		did.DigitalID, did.ConsentHash, did.Issuer, pgTimestamp(did.IssuedAt), pgTimestamp(did.ExpiresAt), pos.txID, pos.block)
+	return err
+}
+
+func upsertIncident(ctx context.Context, q pgQuerier, incident IncidentDocument, pos indexPosition) error {
+	lat, lng := geohashCenter(incident.Geohash)
+	_, err := q.Exec(ctx, `INSERT INTO incidents (incident_id, incident_summary_hash, reporter, status, severity, created_at,
+			acknowledged_at, resolved_at, tx_id, block_number, category, subject_id, geohash, latitude, longitude, owner_org)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
+		ON CONFLICT (incident_id) DO UPDATE SET incident_summary_hash = EXCLUDED.incident_summary_hash,
+			reporter = EXCLUDED.reporter, status = EXCLUDED.status, severity = EXCLUDED.severity,
+			category = EXCLUDED.category, subject_id = EXCLUDED.subject_id,
+			geohash = EXCLUDED.geohash, latitude = EXCLUDED.latitude, longitude = EXCLUDED.longitude, owner_org = EXCLUDED.owner_org,
+			created_at = EXCLUDED.created_at, acknowledged_at = EXCLUDED.acknowledged_at, resolved_at = EXCLUDED.resolved_at,
+			tx_id = EXCLUDED.tx_id, block_number = EXCLUDED.block_number, deleted_at = NULL`,
+		incident.IncidentID, incident.IncidentSummaryHash, incident.Reporter, incident.Status, incident.Severity,
+		pgTimestamp(incident.CreatedAt), pgTimestamp(incident.AcknowledgedAt), pgTimestamp(incident.ResolvedAt),
+		pos.txID, pos.block, incident.Category, incident.SubjectID, incident.Geohash, lat, lng, incident.OwnerOrg)
+	return err
+}
+
+func upsertAssignment(ctx context.Context, q pgQuerier, assignment AssignmentDocument, pos indexPosition) error {
+	_, err := q.Exec(ctx, `INSERT INTO assignments (assignment_id, subject_type, subject_id, unit_id, attempt, status, assigned_at,
+			ack_deadline, acknowledged_at, owner_org, tx_id, block_number)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		ON CONFLICT (assignment_id) DO UPDATE SET status = EXCLUDED.status, ack_deadline = EXCLUDED.ack_deadline,
+			acknowledged_at = EXCLUDED.acknowledged_at, owner_org = EXCLUDED.owner_org, tx_id = EXCLUDED.tx_id,
+			block_number = EXCLUDED.block_number`,
+		assignment.AssignmentID, assignment.SubjectType, assignment.SubjectID, assignment.UnitID, assignment.Attempt, assignment.Status,
+		pgTimestamp(assignment.AssignedAt), pgTimestamp(assignment.AckDeadline), pgTimestamp(assignment.AcknowledgedAt),
+		assignment.OwnerOrg, pos.txID, pos.block)
+	return err
+}
+
+func insertSOS(ctx context.Context, q pgQuerier, sos SOSDocument, pos indexPosition) error {
+	lat, lng := geohashCenter(sos.Geohash)
+	_, err := q.Exec(ctx, `INSERT INTO sos_alerts (alert_id, digital_id, police_unit, geohash, latitude, longitude, raised_at, tx_id, block_number, owner_org)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) ON CONFLICT (alert_id) DO NOTHING`,
+		sos.AlertID, sos.DigitalID, sos.PoliceUnit, sos.Geohash, lat, lng, pgTimestamp(sos.RaisedAt), pos.txID, pos.block, sos.OwnerOrg)
+	return err
+}
+
+func upsertEvidence(ctx context.Context, q pgQuerier, evidence EvidenceDocument, pos indexPosition) error {
+	if evidence.EvidenceID == "" {
+		return nil
+	}
+	_, err := q.Exec(ctx, `INSERT INTO evidence (evidence_id, incident_id, evidence_hash, media_type, uploaded_by, cid,
+			created_at, tx_id, block_number, owner_org, encryption_key_ref)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		ON CONFLICT (evidence_id) DO UPDATE SET incident_id = EXCLUDED.incident_id, evidence_hash = EXCLUDED.evidence_hash,
+			media_type = EXCLUDED.media_type, uploaded_by = EXCLUDED.uploaded_by, cid = EXCLUDED.cid, owner_org = EXCLUDED.owner_org,
+			encryption_key_ref = EXCLUDED.encryption_key_ref, created_at = EXCLUDED.created_at, tx_id = EXCLUDED.tx_id,
+			block_number = EXCLUDED.block_number, deleted_at = NULL`,
+		evidence.EvidenceID, evidence.IncidentID, evidence.EvidenceHash, evidence.MediaType, evidence.UploadedBy, evidence.CID,
+		pgTimestamp(evidence.CreatedAt), pos.txID, pos.block, evidence.OwnerOrg, evidence.EncryptionKeyRef)
+	return err
+}
+
+// archiveIncident records an incident's move to cold storage and drops it and its evidence from the
+// hot tables. Its audit entries stay with the rest of the audit log. A later write to the incident
+// or its evidence projects them again.
+func archiveIncident(ctx context.Context, q pgQuerier, archive IncidentArchive, pos indexPosition) error {
+	if _, err := q.Exec(ctx, `INSERT INTO incident_archives (archive_id, incident_id, digest, object_key, archived_at, owner_org, tx_id, block_number)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8) ON CONFLICT (archive_id) DO NOTHING`,
+		archive.ArchiveID, archive.IncidentID, archive.Digest, archive.ObjectKey, archive.ArchivedAt, archive.OwnerOrg, pos.txID, pos.block); err != nil {
+		return err
+	}
+	if _, err := q.Exec(ctx, `DELETE FROM evidence WHERE incident_id = $1`, archive.IncidentID); err != nil {
+		return err
+	}
+	_, err := q.Exec(ctx, `DELETE FROM incidents WHERE incident_id = $1`, archive.IncidentID)
+	return err
+}
+
+// deleteTargets maps delete events to the table and key column they tombstone
+var deleteTargets = map[string]struct{ table, column string }{
+	"DeleteDID":      {"dids", "digital_id"},
+	"DeleteIncident": {"incidents", "incident_id"},
+	"DeleteEvidence": {"evidence", "evidence_id"},
+}
+
+func tombstone(ctx context.Context, q pgQuerier, table, column, id string, pos indexPosition) error {
+	_, err := q.Exec(ctx, fmt.Sprintf(`UPDATE %s SET deleted_at = now(), tx_id = $2, block_number = $3 WHERE %s = $1`, table, column),
+		id, pos.txID, pos.block)
+	return err
+}
+
+// compact purges rows tombstoned before cutoff, then vacuums the tables to reclaim their space
+func (ix *offchainIndex) compact(ctx context.Context, cutoff time.Time) error {
+	purged := int64(0)
+	for _, event := range []string{"DeleteDID", "DeleteIncident", "DeleteEvidence"} {
+		n, err := ix.db.Exec(ctx, fmt.Sprintf(`DELETE FROM %s WHERE deleted_at < $1`, deleteTargets[event].table), cutoff.UTC().Format(time.RFC3339))
+		if err != nil {
+			return err
+		}
+		purged += n
+	}
+	// VACUUM cannot run in a transaction, so goes through the simple protocol
+	if err := ix.db.ExecScript(ctx, `VACUUM (ANALYZE) dids, incidents, evidence`); err != nil {
+		return err
+	}
+	log.Printf("🗂️  Index compaction purged %d deleted rows", purged)
+	return nil
+}
+
+// projectEvent applies a document event to its table. Deletions are kept as tombstones so a
+// replay cannot resurrect them; events the index does not project only advance the checkpoint.
+func projectEvent(ctx context.Context, q pgQuerier, event *client.ChaincodeEvent) error {
+	pos := eventPosition(event)
+	switch event.EventName {
+	case "CreateDID", "UpdateDID":
+		did, err := decodeDocument[DIDDocument](event.Payload, "DID event")
+		if err != nil {
+			return err
+		}
+		return upsertDID(ctx, q, did, pos)
+
+	case "BatchIssueDID":
+		dids, err := decodeDocument[[]DIDDocument](event.Payload, "DID batch event")
+		if err != nil {
+			return err
+		}
+		for _, did := range dids {
+			if err := upsertDID(ctx, q, did, pos); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case "CreateIncident", "UpdateIncident", "UpdateIncidentStatus":
+		incident, err := decodeDocument[IncidentDocument](event.Payload, "incident event")
+		if err != nil {
+			return err
+		}
+		return upsertIncident(ctx, q, incident, pos)
+
+	case "MergeIncidents":
+		incidents, err := decodeDocument[[]IncidentDocument](event.Payload, "incident merge event")
+		if err != nil {
+			return err
+		}
+		for _, incident := range incidents {
+			if err := upsertIncident(ctx, q, incident, pos); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case "RaiseSOS":
+		sos, err := decodeDocument[SOSDocument](event.Payload, "SOS event")
+		if err != nil {
+			return err
+		}
+		return insertSOS(ctx, q, sos, pos)
+
+	case "CreateEvidence", "UpdateEvidence":
+		evidence, err := decodeDocument[EvidenceDocument](event.Payload, "evidence event")
+		if err != nil {
+			return err
+		}
+		return upsertEvidence(ctx, q, evidence, pos)
+
+	case "CreateAssignment", "AcknowledgeAssignment", "EscalateAssignment", "DeclineAssignment":
+		assignment, err := decodeDocument[AssignmentDocument](event.Payload, "assignment event")
+		if err != nil {
+			return err
+		}
+		return upsertAssignment(ctx, q, assignment, pos)
+
+	case "RecordIncidentArchive":
+		archive, err := decodeDocument[IncidentArchive](event.Payload, "incident archive event")
+		if err != nil {
+			return err
+		}
+		return archiveIncident(ctx, q, archive, pos)
+
+	case "RecordAPIAudits":
+		audits, err := decodeDocument[[]AuditDocument](event.Payload, "API audit event")
+		if err != nil {
+			return err
+		}
+		for _, audit := range audits {
+			if err := insertAudit(ctx, q, audit); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case "DeleteDID", "DeleteIncident", "DeleteEvidence":
+		target := deleteTargets[event.EventName]
+		var doc map[string]interface{}
+		if err := json.Unmarshal(event.Payload, &doc); err != nil {
+			return &malformedDocumentError{kind: "delete event", err: err}
+		}
+		id, _ := doc[target.column].(string)
+		if id == "" {
+			return fmt.Errorf("%s event has no %s", event.EventName, target.column)
+		}
+		return tombstone(ctx, q, target.table, target.column, id, pos)
+	}
+	return nil
+}
+
+// pgTimestamp turns an empty ledger timestamp into NULL
+func pgTimestamp(value string) interface{} {
+	if value == "" {
+		return nil
+	}
+	return value
+}
+
+// utcColumn formats a timestamptz column the way the chaincode writes timestamps
+func utcColumn(column string) string {
+	return fmt.Sprintf(`to_char(%s AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS"Z"')`, column)
+}
+
+// encodeIndexBookmark packs the sort key of the last row returned into an opaque bookmark
+func encodeIndexBookmark(key ...string) string {
+	data, _ := json.Marshal(key)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeIndexBookmark(bookmark string, size int) ([]string, error) {
+	var key []string
+	data, err := base64.RawURLEncoding.DecodeString(bookmark)
+	if err == nil {
+		err = json.Unmarshal(data, &key)
+	}
+	if err != nil || len(key) != size {
+		return nil, ValidationErrors{{Field: "bookmark", Message: "is not a bookmark returned by this endpoint"}}
+	}
+	return key, nil
+}
+
+// queryFilter accumulates WHERE conditions with numbered placeholders
+type queryFilter struct {
+	conditions []string
+	args       []interface{}
+}
+
+func (f *queryFilter) add(condition string, args ...interface{}) {
+	for _, arg := range args {
+		f.args = append(f.args, arg)
+		condition = strings.Replace(condition, "?", fmt.Sprintf("$%d", len(f.args)), 1)
+	}
+	f.conditions = append(f.conditions, condition)
+}
+
+func (f *queryFilter) where() string {
+	if len(f.conditions) == 0 {
+		return "TRUE"
+	}
+	return strings.Join(f.conditions, " AND ")
+}
+
+// SearchIncidents mirrors the ledger query: created_at in [from, to), newest first
+func (ix *offchainIndex) SearchIncidents(ctx context.Context, req IncidentSearchRequest, t tenant) (IncidentPage, error) {
+	var f queryFilter
+	f.add("deleted_at IS NULL")
+	t.restrict(&f, "owner_org")
+	if req.From != "" {
+		f.add("created_at >= ?", req.From)
+	}
+	if req.To != "" {
+		f.add("created_at < ?", req.To)
+	}
+	if req.Status != "" {
+		f.add("status = ?", req.Status)
+	}
+	if req.Severity != "" {
+		f.add("severity = ?", req.Severity)
+	}
+	if req.Reporter != "" {
+		f.add("reporter = ?", req.Reporter)
+	}
+	if req.Bookmark != "" {
+		key, err := decodeIndexBookmark(req.Bookmark, 2)
+		if err != nil {
+			return IncidentPage{}, err
+		}
+		f.add("(created_at, incident_id) < (?::timestamptz, ?)", key[0], key[1])
+	}
+
+	rows, err := ix.db.Query(ctx, fmt.Sprintf(`SELECT incident_id, incident_summary_hash, %s, reporter, status, severity, %s, %s, tx_id, category, subject_id, geohash, owner_org
+		FROM incidents WHERE %s ORDER BY created_at DESC, incident_id DESC LIMIT %d`,
+		utcColumn("created_at"), utcColumn("acknowledged_at"), utcColumn("resolved_at"), f.where(), req.PageSize),
+		f.args...)
+	if err != nil {
+		return IncidentPage{}, err
+	}
+
+	page := IncidentPage{Incidents: incidentRows(rows), Count: len(rows)}
+	if len(rows) == req.PageSize {
+		last := page.Incidents[len(page.Incidents)-1]
+		page.Bookmark = encodeIndexBookmark(last.CreatedAt, last.IncidentID)
+	}
+	return page, nil
+}
+
+// ListOpenIncidentsBySubject mirrors the ledger query: the tourist's unresolved incidents, newest
+// first
+func (ix *offchainIndex) ListOpenIncidentsBySubject(ctx context.Context, subjectID string) ([]IncidentDocument, error) {
+	rows, err := ix.db.Query(ctx, fmt.Sprintf(`SELECT incident_id, incident_summary_hash, %s, reporter, status, severity, %s, %s, tx_id, category, subject_id, geohash, owner_org
+		FROM incidents WHERE subject_id = $1 AND deleted_at IS NULL AND status NOT IN ('resolved', 'closed')
+		ORDER BY created_at DESC, incident_id DESC`,
+		utcColumn("created_at"), utcColumn("acknowledged_at"), utcColumn("resolved_at")), subjectID)
+	if err != nil {
+		return nil, err
+	}
+	return incidentRows(rows), nil
+}
+
+func incidentRows(rows []pgRow) []IncidentDocument {
+	incidents := make([]IncidentDocument, 0, len(rows))
+	for _, row := range rows {
+		incidents = append(incidents, IncidentDocument{
+			DocType:             "incident",
+			IncidentID:          row.String(0),
+			IncidentSummaryHash: row.String(1),
+			CreatedAt:           row.String(2),
+			Reporter:            row.String(3),
+			Status:              row.String(4),
+			Severity:            row.String(5),
+			AcknowledgedAt:      row.String(6),
+			ResolvedAt:          row.String(7),
+			TxID:                row.String(8),
+			Category:            row.String(9),
+			SubjectID:           row.String(10),
+			Geohash:             row.String(11),
+			OwnerOrg:            row.String(12),
+		})
+	}
+	return incidents
+}
+
+// SearchAudits mirrors the ledger query: timestamp in [from, to), newest first
+func (ix *offchainIndex) SearchAudits(ctx context.Context, req AuditSearchRequest, t tenant) (AuditPage, error) {
+	var f queryFilter
+	t.restrict(&f, "split_part(submitter, '/', 1)")
+	if req.Actor != "" {
+		f.add("actor = ?", req.Actor)
+	}
+	if req.Action != "" {
+		f.add("action = ?", req.Action)
+	}
+	if req.From != "" {
+		f.add("timestamp >= ?", req.From)
+	}
+	if req.To != "" {
+		f.add("timestamp < ?", req.To)
+	}
+	if req.Bookmark != "" {
+		key, err := decodeIndexBookmark(req.Bookmark, 5)
+		if err != nil {
+			return AuditPage{}, err
+		}
+		f.add("(timestamp, tx_id, target_id, action, request_id) < (?::timestamptz, ?, ?, ?, ?)", key[0], key[1], key[2], key[3], key[4])
+	}
+
+	rows, err := ix.db.Query(ctx, fmt.Sprintf(`SELECT audit_hash, actor, action, target_id, %s, tx_id, submitter, route, request_id, status FROM audits
+		WHERE %s ORDER BY timestamp DESC, tx_id DESC, target_id DESC, action DESC, request_id DESC LIMIT %d`,
+		utcColumn("timestamp"), f.where(), req.PageSize), f.args...)
+	if err != nil {
+		return AuditPage{}, err
+	}
+
+	page := AuditPage{Audits: auditRows(rows), Count: len(rows)}
+	if len(rows) == req.PageSize {
+		last := page.Audits[len(page.Audits)-1]
+		page.Bookmark = encodeIndexBookmark(last.Timestamp, last.TxID, last.TargetID, last.Action, last.RequestID)
+	}
+	return page, nil
+}
+
+// ListAuditsByTarget returns every audit entry for a document, oldest first
+func (ix *offchainIndex) ListAuditsByTarget(ctx context.Context, targetID string) ([]AuditDocument, error) {
+	rows, err := ix.db.Query(ctx, fmt.Sprintf(`SELECT audit_hash, actor, action, target_id, %s, tx_id, submitter, route, request_id, status FROM audits
+		WHERE target_id = $1 ORDER BY timestamp, tx_id`, utcColumn("timestamp")), targetID)
+	if err != nil {
+		return nil, err
+	}
+	return auditRows(rows), nil
+}
+
+func auditRows(rows []pgRow) []AuditDocument {
+	audits := make([]AuditDocument, 0, len(rows))
+	for _, row := range rows {
+		audits = append(audits, AuditDocument{
+			DocType:   "audit",
+			AuditHash: row.String(0),
+			Actor:     row.String(1),
+			Action:    row.String(2),
+			TargetID:  row.String(3),
+			Timestamp: row.String(4),
+			TxID:      row.String(5),
+			Submitter: row.String(6),
+			Route:     row.String(7),
+			RequestID: row.String(8),
+			Status:    row.String(9),
+		})
+	}
+	return audits
+}
+
+// evidenceOrders maps the evidence list sorts to ORDER BY clauses
+var evidenceOrders = map[string]string{
+	"created_at":   "created_at, evidence_id",
+	"-created_at":  "created_at DESC, evidence_id DESC",
+	"media_type":   "media_type, created_at, evidence_id",
+	"-media_type":  "media_type DESC, created_at, evidence_id",
+	"uploaded_by":  "uploaded_by, created_at, evidence_id",
+	"-uploaded_by": "uploaded_by DESC, created_at, evidence_id",
+}
+
+// ListEvidenceByIncident mirrors the ledger query: created_at in [from, to), oldest first unless
+// req asks for another order
+func (ix *offchainIndex) ListEvidenceByIncident(ctx context.Context, incidentID string, req EvidenceListRequest) ([]EvidenceDocument, error) {
+	var f queryFilter
+	f.add("incident_id = ?", incidentID)
+	f.add("deleted_at IS NULL")
+	if req.MediaType != "" {
+		f.add("media_type = ?", req.MediaType)
+	}
+	if req.UploadedBy != "" {
+		f.add("uploaded_by = ?", req.UploadedBy)
+	}
+	if req.From != "" {
+		f.add("created_at >= ?", req.From)
+	}
+	if req.To != "" {
+		f.add("created_at < ?", req.To)
+	}
+	order, ok := evidenceOrders[req.Sort]
+	if !ok {
+		order = evidenceOrders["created_at"]
+	}
+
+	rows, err := ix.db.Query(ctx, fmt.Sprintf(`SELECT evidence_id, evidence_hash, incident_id, media_type, uploaded_by, %s, cid, tx_id, owner_org, encryption_key_ref
+		FROM evidence WHERE %s ORDER BY %s`, utcColumn("created_at"), f.where(), order), f.args...)
+	if err != nil {
+		return nil, err
+	}
+	evidence := make([]EvidenceDocument, 0, len(rows))
+	for _, row := range rows {
+		evidence = append(evidence, EvidenceDocument{
+			DocType:          "evidence",
+			EvidenceID:       row.String(0),
+			EvidenceHash:     row.String(1),
+			IncidentID:       row.String(2),
+			MediaType:        row.String(3),
+			UploadedBy:       row.String(4),
+			CreatedAt:        row.String(5),
+			CID:              row.String(6),
+			TxID:             row.String(7),
+			OwnerOrg:         row.String(8),
+			EncryptionKeyRef: row.String(9),
+		})
+	}
+	return evidence, nil
+}
+
+// indexStatsSource computes dashboard statistics with aggregate queries over the projections
+type indexStatsSource struct {
+	index *offchainIndex
+}
+
+func (indexStatsSource) Name() string {
+	return "index"
+}
+
+func (s indexStatsSource) DashboardStats(ctx context.Context, query statsQuery) (DashboardStats, error) {
+	db := s.index.db
+	now := query.now.UTC()
+	recentSince := now.Add(-24 * time.Hour)
+	stats := DashboardStats{OpenIncidentsBySeverity: map[string]int{}}
+
+	rows, err := db.Query(ctx, `SELECT COALESCE(NULLIF(severity, ''), 'unclassified'), count(*) FROM incidents
+		WHERE deleted_at IS NULL AND status IN ('', 'open', 'acknowledged') GROUP BY 1`)
+	if err != nil {
+		return DashboardStats{}, err
+	}
+	for _, row := range rows {
+		stats.OpenIncidentsBySeverity[row.String(0)] = int(row.Int(1))
+	}
+
+	rows, err = db.Query(ctx, `SELECT count(*), avg(extract(epoch FROM acknowledged_at - created_at)) FROM incidents
+		WHERE deleted_at IS NULL AND acknowledged_at >= $1 AND acknowledged_at >= created_at`, now.Add(-query.acknowledgeWindow))
+	if err != nil {
+		return DashboardStats{}, err
+	}
+	if rows[0].Int(0) > 0 {
+		avg := rows[0].Float(1)
+		stats.AvgTimeToAcknowledge = &avg
+	}
+
+	rows, err = db.Query(ctx, `SELECT count(*) FROM audits WHERE action = 'RAISE_SOS' AND timestamp >= $1`, recentSince)
+	if err != nil {
+		return DashboardStats{}, err
+	}
+	stats.SOSLast24h = int(rows[0].Int(0))
+
+	rows, err = db.Query(ctx, `SELECT count(*), count(*) FILTER (WHERE issued_at >= $1),
+			count(*) FILTER (WHERE expires_at >= $2 AND expires_at < $3)
+		FROM dids WHERE deleted_at IS NULL`, recentSince, now, now.Add(query.expiringWindow))
+	if err != nil {
+		return DashboardStats{}, err
+	}
+	stats.DIDs = DIDStats{Issued: int(rows[0].Int(0)), IssuedLast24h: int(rows[0].Int(1)), Expiring: int(rows[0].Int(2))}
+
+	rows, err = db.Query(ctx, `SELECT count(*), count(*) FILTER (WHERE created_at >= $1) FROM evidence WHERE deleted_at IS NULL`, recentSince)
+	if err != nil {
+		return DashboardStats{}, err
+	}
+	stats.Evidence = EvidenceStats{Total: int(rows[0].Int(0)), Last24h: int(rows[0].Int(1))}
+	return stats, nil
+}
+
+// indexedTo returns the last block the index applied and whether an indexer is running
+func (ix *offchainIndex) indexedTo(ctx context.Context) (uint64, bool, error) {
+	indexed, running := ix.lastBlock.Load(), ix.running.Load()
+	if ix.external {
+		// sih-indexer runs elsewhere; its checkpoint is the only sign of progress
+		rows, err := ix.db.Query(ctx, `SELECT block_number FROM index_checkpoints WHERE name = $1`, blockCheckpointName(defaultTarget))
+		if err != nil {
+			return 0, false, err
+		}
+		if running = len(rows) > 0; running {
+			indexed = uint64(rows[0].Int(0))
+		}
+	}
+	return indexed, running, nil
+}
+
+// checkOffchainIndex reports database reachability and how far the indexer trails the ledger
+func checkOffchainIndex(ctx context.Context, height uint64) DependencyStatus {
+	if offchain == nil {
+		return DependencyStatus{Status: statusOK, Detail: "disabled"}
+	}
+
+	start := time.Now()
+	err := offchain.db.Ping(ctx)
+	latency := time.Since(start).Milliseconds()
+	if err != nil {
+		return DependencyStatus{Status: statusDegraded, LatencyMS: latency, Error: err.Error()}
+	}
+
+	indexed, running, err := offchain.indexedTo(ctx)
+	if err != nil {
+		return DependencyStatus{Status: statusDegraded, LatencyMS: latency, Error: err.Error()}
+	}
+
+	status := DependencyStatus{Status: statusOK, LatencyMS: latency, Detail: fmt.Sprintf("indexed to block %d", indexed)}
+	if !running {
+		status.Status = statusDegraded
+		status.Error = "indexer not running"
+	} else if height > 0 && indexed+1 < height {
+		status.Detail += fmt.Sprintf(", %d blocks behind", height-1-indexed)
+	}
+	return status
+}
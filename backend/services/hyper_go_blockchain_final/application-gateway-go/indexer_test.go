@@ -0,0 +1,109 @@
+package gateway
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+// recordingQuerier captures the statements a projection issues
+type recordingQuerier struct {
+	queries []string
+	args    [][]interface{}
+}
+
+func (r *recordingQuerier) Exec(ctx context.Context, query string, args ...interface{}) (int64, error) {
+	r.queries = append(r.queries, query)
+	r.args = append(r.args, args)
+	return 1, nil
+}
+
+func (r *recordingQuerier) Query(ctx context.Context, query string, args ...interface{}) ([]pgRow, error) {
+	r.queries = append(r.queries, query)
+	r.args = append(r.args, args)
+	return nil, nil
+}
+
+func TestProjectEvent(t *testing.T) {
+	tests := []struct {
+		name      string
+		payload   string
+		wantQuery string
+		wantArg   interface{}
+	}{
+		{"UpdateIncidentStatus", `{"doc_type":"incident","incident_id":"inc_1","created_at":"2025-09-20T13:19:10Z","status":"acknowledged","acknowledged_at":"2025-09-20T13:31:42Z"}`, "INSERT INTO incidents", "acknowledged"},
+		{"CreateDID", `{"doc_type":"did","digital_id":"did:sih:t1","issued_at":"2025-09-20T13:19:10Z","expires_at":"2026-09-20T13:19:10+05:30"}`, "INSERT INTO dids", "did:sih:t1"},
+		{"DeleteEvidence", `{"doc_type":"evidence","evidence_id":"ev_1"}`, "UPDATE evidence SET deleted_at", "ev_1"},
+		{"RaiseSOS", `{"doc_type":"sos","alert_id":"SOS-1","digital_id":"did:sih:t1","geohash":"tuscxy","raised_at":"2025-09-20T13:19:10Z"}`, "INSERT INTO sos_alerts", "tuscxy"},
+		{"AcknowledgeAssignment", `{"doc_type":"assignment","assignment_id":"AS-1","subject_type":"incident","subject_id":"inc_1","unit_id":"shillong","attempt":1,"status":"acknowledged","assigned_at":"2025-09-20T13:20:00Z"}`, "INSERT INTO assignments", "acknowledged"},
+		{"RecordEFIR", `{"fir_id":"FIR-1","incident_id":"inc_1"}`, "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := &recordingQuerier{}
+			event := &client.ChaincodeEvent{EventName: tt.name, Payload: []byte(tt.payload), TransactionID: "tx1", BlockNumber: 7}
+			if err := projectEvent(context.Background(), q, event); err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantQuery == "" {
+				if len(q.queries) != 0 {
+					t.Fatalf("expected no statements, got %v", q.queries)
+				}
+				return
+			}
+			if len(q.queries) != 1 || !strings.Contains(q.queries[0], tt.wantQuery) {
+				t.Fatalf("expected %q, got %v", tt.wantQuery, q.queries)
+			}
+			found := false
+			for _, arg := range q.args[0] {
+				found = found || arg == tt.wantArg
+			}
+			if !found {
+				t.Errorf("expected argument %v in %v", tt.wantArg, q.args[0])
+			}
+		})
+	}
+}
+
+func TestProjectEventMissingTimestampIsNull(t *testing.T) {
+	q := &recordingQuerier{}
+	event := &client.ChaincodeEvent{EventName: "CreateIncident", Payload: []byte(`{"incident_id":"inc_1","created_at":"2025-09-20T13:19:10Z","status":"open"}`)}
+	if err := projectEvent(context.Background(), q, event); err != nil {
+		t.Fatal(err)
+	}
+	// acknowledged_at and resolved_at are the 7th and 8th parameters
+	if q.args[0][6] != nil || q.args[0][7] != nil {
+		t.Errorf("expected NULL lifecycle timestamps, got %v %v", q.args[0][6], q.args[0][7])
+	}
+}
+
+func TestQueryFilter(t *testing.T) {
+	var f queryFilter
+	if f.where() != "TRUE" {
+		t.Errorf("empty filter should match everything, got %q", f.where())
+	}
+	f.add("deleted_at IS NULL")
+	f.add("created_at >= ?", "2025-01-01T00:00:00Z")
+	f.add("(created_at, incident_id) < (?::timestamptz, ?)", "2025-02-01T00:00:00Z", "inc_9")
+	want := "deleted_at IS NULL AND created_at >= $1 AND (created_at, incident_id) < ($2::timestamptz, $3)"
+	if f.where() != want || len(f.args) != 3 || f.args[2] != "inc_9" {
+		t.Errorf("got %q %v", f.where(), f.args)
+	}
+}
+
+func TestIndexBookmark(t *testing.T) {
+	bookmark := encodeIndexBookmark("2025-09-20T13:19:10Z", "inc_1")
+	key, err := decodeIndexBookmark(bookmark, 2)
+	if err != nil || key[0] != "2025-09-20T13:19:10Z" || key[1] != "inc_1" {
+		t.Fatalf("round trip failed: %v %v", key, err)
+	}
+	if _, err := decodeIndexBookmark(bookmark, 4); err == nil {
+		t.Error("expected a bookmark from another endpoint to be rejected")
+	}
+	if _, err := decodeIndexBookmark("g1AAAAB", 2); err == nil {
+		t.Error("expected a ledger bookmark to be rejected")
+	}
+}
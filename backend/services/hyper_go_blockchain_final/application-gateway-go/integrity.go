@@ -0,0 +1,349 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// integrityKinds are the off-chain files sih-verify checks against the ledger
+var integrityKinds = []string{"evidence", "efir", "timeline", "report", "archive"}
+
+const integrityReportType = "sih-integrity+jwt"
+
+// Integrity check outcomes. Every outcome but verified is a discrepancy.
+const (
+	integrityVerified   = "verified"
+	integrityMismatch   = "mismatch"
+	integrityMissing    = "missing"
+	integrityUnreadable = "unreadable"
+)
+
+// IntegrityItem is one stored file compared with the hash anchored for it
+type IntegrityItem struct {
+	Kind         string `json:"kind"`
+	ID           string `json:"id"`
+	IncidentID   string `json:"incident_id,omitempty"`
+	StorageKey   string `json:"storage_key"`
+	AnchoredHash string `json:"anchored_hash"`
+	StoredHash   string `json:"stored_hash,omitempty"`
+	AnchorTxID   string `json:"anchor_tx_id"`
+	Status       string `json:"status"`
+	Detail       string `json:"detail,omitempty"`
+}
+
+// IntegritySummary counts the files checked by outcome
+type IntegritySummary struct {
+	Checked    int `json:"checked"`
+	Verified   int `json:"verified"`
+	Mismatched int `json:"mismatched"`
+	Missing    int `json:"missing"`
+	Unreadable int `json:"unreadable"`
+}
+
+// IntegrityReport is the signed outcome of one sih-verify run. Discrepancies lists every file that
+// did not verify; Verified lists the rest, so the report accounts for every anchored file in scope.
+type IntegrityReport struct {
+	ReportID      string           `json:"report_id"`
+	Issuer        string           `json:"issuer"`
+	Channel       string           `json:"channel"`
+	Chaincode     string           `json:"chaincode"`
+	IncidentID    string           `json:"incident_id,omitempty"`
+	Kinds         []string         `json:"kinds"`
+	StartedAt     string           `json:"started_at"`
+	CompletedAt   string           `json:"completed_at"`
+	Summary       IntegritySummary `json:"summary"`
+	Discrepancies []IntegrityItem  `json:"discrepancies"`
+	Verified      []IntegrityItem  `json:"verified"`
+	SigningKey    gin.H            `json:"signing_key"`
+}
+
+func (r *IntegrityReport) add(item IntegrityItem) {
+	r.Summary.Checked++
+	switch item.Status {
+	case integrityVerified:
+		r.Summary.Verified++
+		r.Verified = append(r.Verified, item)
+		return
+	case integrityMismatch:
+		r.Summary.Mismatched++
+	case integrityMissing:
+		r.Summary.Missing++
+	default:
+		r.Summary.Unreadable++
+	}
+	r.Discrepancies = append(r.Discrepancies, item)
+}
+
+// integrityChecker re-hashes the files in the evidence store and compares them with the ledger
+type integrityChecker struct {
+	fetch func(ctx context.Context, docType, bookmark string) (DocumentPage, error)
+	// keys opens encrypted evidence; without it encrypted files are unreadable
+	keys       *evidenceKeyring
+	incidentID string
+}
+
+// walk calls fn with every document of a type, page by page
+func (k *integrityChecker) walk(ctx context.Context, docType string, fn func(doc json.RawMessage) error) error {
+	bookmark := ""
+	for {
+		page, err := k.fetch(ctx, docType, bookmark)
+		if err != nil {
+			return fmt.Errorf("failed to read %s documents: %w", docType, err)
+		}
+		for _, doc := range page.Documents {
+			if err := fn(doc); err != nil {
+				return err
+			}
+		}
+		if page.Bookmark == "" {
+			return nil
+		}
+		bookmark = page.Bookmark
+	}
+}
+
+// hash compares the stored file with its anchored hash, reading it through plaintext
+func (k *integrityChecker) hash(ctx context.Context, item IntegrityItem, plaintext func(io.Reader) io.Reader) IntegrityItem {
+	stored, err := hashStoredPlaintext(ctx, item.StorageKey, plaintext)
+	switch {
+	case errors.Is(err, errObjectNotFound):
+		item.Status, item.Detail = integrityMissing, "no stored file"
+	case errors.Is(err, errEvidenceUndecryptable):
+		item.Status, item.Detail = integrityMismatch, "the stored file does not decrypt with its data key"
+	case err != nil:
+		item.Status, item.Detail = integrityUnreadable, err.Error()
+	case stored != item.AnchoredHash:
+		item.StoredHash, item.Status = stored, integrityMismatch
+	default:
+		item.StoredHash, item.Status = stored, integrityVerified
+	}
+	return item
+}
+
+func (k *integrityChecker) checkEvidence(ctx context.Context, report *IntegrityReport) error {
+	return k.walk(ctx, "evidence", func(doc json.RawMessage) error {
+		evidence, err := decodeDocument[EvidenceDocument](doc, "evidence")
+		if err != nil {
+			return err
+		}
+		if k.incidentID != "" && evidence.IncidentID != k.incidentID {
+			return nil
+		}
+		item := IntegrityItem{
+			Kind: "evidence", ID: evidence.EvidenceID, IncidentID: evidence.IncidentID,
+			StorageKey: evidenceObjectKey(evidence.IncidentID, evidence.EvidenceID), AnchoredHash: evidence.EvidenceHash, AnchorTxID: evidence.TxID,
+		}
+		plaintext := storedAsIs
+		if evidence.EncryptionKeyRef != "" {
+			if k.keys == nil {
+				item.Status, item.Detail = integrityUnreadable, "the file is encrypted and EVIDENCE_ENCRYPTION is not configured"
+				report.add(item)
+				return nil
+			}
+			aead, err := k.keys.open(ctx, evidence.EncryptionKeyRef)
+			if err != nil {
+				item.Status, item.Detail = integrityUnreadable, err.Error()
+				report.add(item)
+				return nil
+			}
+			plaintext = func(r io.Reader) io.Reader { return newDecryptingReader(r, aead, item.StorageKey) }
+		}
+		report.add(k.hash(ctx, item, plaintext))
+		return ctx.Err()
+	})
+}
+
+func (k *integrityChecker) checkEFIRs(ctx context.Context, report *IntegrityReport) error {
+	return k.walk(ctx, "efir", func(doc json.RawMessage) error {
+		efir, err := decodeDocument[EFIRDocument](doc, "e-FIR")
+		if err != nil {
+			return err
+		}
+		if k.incidentID != "" && efir.IncidentID != k.incidentID {
+			return nil
+		}
+		report.add(k.hash(ctx, IntegrityItem{
+			Kind: "efir", ID: efir.FIRID, IncidentID: efir.IncidentID,
+			StorageKey: efirStorageKey(efir.IncidentID, efir.FIRID), AnchoredHash: efir.DocumentHash, AnchorTxID: efir.TxID,
+		}, storedAsIs))
+		return ctx.Err()
+	})
+}
+
+func (k *integrityChecker) checkTimelineAnnexures(ctx context.Context, report *IntegrityReport) error {
+	return k.walk(ctx, "timeline_annexure", func(doc json.RawMessage) error {
+		annexure, err := decodeDocument[TimelineAnnexure](doc, "timeline annexure")
+		if err != nil {
+			return err
+		}
+		if k.incidentID != "" && annexure.IncidentID != k.incidentID {
+			return nil
+		}
+		report.add(k.hash(ctx, IntegrityItem{
+			Kind: "timeline", ID: annexure.AnnexureID, IncidentID: annexure.IncidentID,
+			StorageKey: timelineStorageKey(annexure.IncidentID, annexure.AnnexureID), AnchoredHash: annexure.DocumentHash, AnchorTxID: annexure.TxID,
+		}, storedAsIs))
+		return ctx.Err()
+	})
+}
+
+// checkReports checks both files of each operations report. Reports cover periods rather than
+// incidents, so a check scoped to an incident leaves them out.
+func (k *integrityChecker) checkReports(ctx context.Context, report *IntegrityReport) error {
+	if k.incidentID != "" {
+		return nil
+	}
+	return k.walk(ctx, "report_anchor", func(doc json.RawMessage) error {
+		anchor, err := decodeDocument[ReportAnchor](doc, "report anchor")
+		if err != nil {
+			return err
+		}
+		for _, format := range []string{"pdf", "csv"} {
+			hash := anchor.PDFHash
+			if format == "csv" {
+				hash = anchor.CSVHash
+			}
+			report.add(k.hash(ctx, IntegrityItem{
+				Kind: "report", ID: anchor.ReportID,
+				StorageKey: reportStorageKey(anchor, format), AnchoredHash: hash, AnchorTxID: anchor.TxID,
+			}, storedAsIs))
+		}
+		return ctx.Err()
+	})
+}
+
+// check walks the anchors of each kind in turn and compares their stored files
+func (k *integrityChecker) check(ctx context.Context, kinds []string, report *IntegrityReport) error {
+	checks := map[string]func(context.Context, *IntegrityReport) error{
+		"evidence": k.checkEvidence,
+		"efir":     k.checkEFIRs,
+		"timeline": k.checkTimelineAnnexures,
+		"report":   k.checkReports,
+		"archive":  k.checkArchives,
+	}
+	for _, kind := range kinds {
+		if err := checks[kind](ctx, report); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// signIntegrityReport signs the report as a compact JWS, naming the key as issuer#keyID
+func signIntegrityReport(signer *qrSigner, report *IntegrityReport) (string, error) {
+	kid := report.Issuer + "#" + signer.keyID
+	report.SigningKey = signer.publicJWK(kid)
+	return signer.signJWT(integrityReportType, kid, report)
+}
+
+// loadIntegritySigner loads the report signing key, or generates one that only signs this run
+func loadIntegritySigner(path string) (*qrSigner, error) {
+	if path != "" {
+		return loadQRSigner(path)
+	}
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	signer := newQRSigner(key)
+	log.Printf("⚠️  VERIFY_SIGNING_KEY_FILE not set; the report is signed with an ephemeral key (%s) that cannot be checked later", signer.keyID)
+	return signer, nil
+}
+
+// RunIntegrityCheck is the sih-verify entry point
+func RunIntegrityCheck() {
+	flags := flag.NewFlagSet("sih-verify", flag.ExitOnError)
+	incidentID := flags.String("incident", "", "check only the evidence, e-FIRs, timeline annexures and archives of this incident")
+	kindList := flags.String("kinds", strings.Join(integrityKinds, ","), "files to check: evidence, efir, timeline, report and archive")
+	out := flags.String("out", "", "report file, by default integrity-<time>.json; the signed report is written beside it as .jws")
+	keyFile := flags.String("signing-key", getEnv("VERIFY_SIGNING_KEY_FILE", ""), "Ed25519 PKCS#8 PEM key that signs the report")
+	issuer := flags.String("issuer", getEnv("VERIFY_ISSUER", "did:sih:gateway"), "issuer named in the report and its key ID")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: sih-verify [flags]\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(os.Args[1:])
+	kinds := splitList(*kindList)
+	for _, kind := range kinds {
+		if !slices.Contains(integrityKinds, kind) {
+			log.Fatalf("unknown kind %q, expected %s", kind, strings.Join(integrityKinds, ", "))
+		}
+	}
+	if *incidentID != "" && !identifierRegex.MatchString(*incidentID) {
+		log.Fatalf("invalid incident ID %q", *incidentID)
+	}
+	signer, err := loadIntegritySigner(*keyFile)
+	if err != nil {
+		log.Fatalf("Failed to load the report signing key: %v", err)
+	}
+
+	initFabricConnection()
+	defer closeFabricConnection()
+	initEvidenceStore()
+	initArchiveStore()
+	initEvidenceEncryption()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	started := time.Now().UTC()
+	report := &IntegrityReport{
+		ReportID:      "INTEGRITY-" + started.Format("20060102T150405Z"),
+		Issuer:        *issuer,
+		Channel:       defaultTarget.Channel,
+		Chaincode:     defaultTarget.Chaincode,
+		IncidentID:    *incidentID,
+		Kinds:         kinds,
+		StartedAt:     started.Format(time.RFC3339),
+		Discrepancies: []IntegrityItem{},
+		Verified:      []IntegrityItem{},
+	}
+	checker := &integrityChecker{fetch: ledger.ExportDocuments, keys: evidenceKeys, incidentID: *incidentID}
+	if err := checker.check(withTarget(ctx, defaultTarget), kinds, report); err != nil {
+		stop()
+		log.Fatalf("❌ Verification stopped after %d files: %v", report.Summary.Checked, err)
+	}
+	report.CompletedAt = time.Now().UTC().Format(time.RFC3339)
+
+	signed, err := signIntegrityReport(signer, report)
+	if err != nil {
+		log.Fatalf("Failed to sign the report: %v", err)
+	}
+	filename := *out
+	if filename == "" {
+		filename = "integrity-" + started.Format("20060102T150405Z") + ".json"
+	}
+	if err := writeJSONFile(filename, report); err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(filename+".jws", []byte(signed+"\n"), 0o644); err != nil {
+		log.Fatal(err)
+	}
+
+	s := report.Summary
+	log.Printf("🔎 Checked %d files: %d verified, %d mismatched, %d missing, %d unreadable; report in %s", s.Checked, s.Verified, s.Mismatched, s.Missing, s.Unreadable, filename)
+	if len(report.Discrepancies) > 0 {
+		stop()
+		os.Exit(1)
+	}
+}
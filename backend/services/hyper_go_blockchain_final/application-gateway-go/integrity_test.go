@@ -0,0 +1,112 @@
+package gateway
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestIntegrityCheck(t *testing.T) {
+	store, err := newLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := evidenceStore
+	evidenceStore = store
+	defer func() { evidenceStore = previous }()
+
+	ctx := context.Background()
+	put := func(key, content string) string {
+		if err := store.Put(ctx, key, strings.NewReader(content), -1, "application/octet-stream"); err != nil {
+			t.Fatal(err)
+		}
+		sum := sha256.Sum256([]byte(content))
+		return hex.EncodeToString(sum[:])
+	}
+	photo := put(evidenceObjectKey("INC-1", "EV-1"), "photo")
+	put(evidenceObjectKey("INC-1", "EV-2"), "edited video")
+	video := sha256.Sum256([]byte("video"))
+	fir := put(efirStorageKey("INC-1", "FIR-1"), "%PDF fir")
+	other := put(evidenceObjectKey("INC-2", "EV-4"), "other")
+	anchor := ReportAnchor{ReportID: "RPT:daily:2025-09-20", Period: "daily", TxID: "tx-r"}
+	anchor.PDFHash = put(reportStorageKey(anchor, "pdf"), "%PDF report")
+	anchor.CSVHash = put(reportStorageKey(anchor, "csv"), "day,incidents")
+
+	docs := map[string][]interface{}{
+		"evidence": {
+			EvidenceDocument{EvidenceID: "EV-1", IncidentID: "INC-1", EvidenceHash: photo, TxID: "tx-1"},
+			EvidenceDocument{EvidenceID: "EV-2", IncidentID: "INC-1", EvidenceHash: hex.EncodeToString(video[:]), TxID: "tx-2"},
+			EvidenceDocument{EvidenceID: "EV-3", IncidentID: "INC-1", EvidenceHash: photo, TxID: "tx-3"},
+			EvidenceDocument{EvidenceID: "EV-4", IncidentID: "INC-2", EvidenceHash: other, TxID: "tx-4"},
+			EvidenceDocument{EvidenceID: "EV-5", IncidentID: "INC-2", EvidenceHash: other, EncryptionKeyRef: "key-1", TxID: "tx-5"},
+		},
+		"efir":          {EFIRDocument{FIRID: "FIR-1", IncidentID: "INC-1", DocumentHash: fir, TxID: "tx-f"}},
+		"report_anchor": {anchor},
+	}
+	// Each page holds two documents, so walking follows the bookmarks
+	fetch := func(_ context.Context, docType, bookmark string) (DocumentPage, error) {
+		start := 0
+		if bookmark != "" {
+			start = len(bookmark)
+		}
+		var page DocumentPage
+		for i := start; i < len(docs[docType]) && i < start+2; i++ {
+			doc, _ := json.Marshal(docs[docType][i])
+			page.Documents = append(page.Documents, doc)
+		}
+		if start+2 < len(docs[docType]) {
+			page.Bookmark = strings.Repeat("x", start+2)
+		}
+		return page, nil
+	}
+
+	report := &IntegrityReport{}
+	checker := &integrityChecker{fetch: fetch}
+	if err := checker.check(ctx, integrityKinds, report); err != nil {
+		t.Fatal(err)
+	}
+	if s := report.Summary; s.Checked != 8 || s.Verified != 5 || s.Mismatched != 1 || s.Missing != 1 || s.Unreadable != 1 {
+		t.Errorf("unexpected summary %+v", s)
+	}
+	statuses := map[string]string{}
+	for _, item := range report.Discrepancies {
+		statuses[item.ID] = item.Status
+	}
+	if statuses["EV-2"] != integrityMismatch || statuses["EV-3"] != integrityMissing || statuses["EV-5"] != integrityUnreadable {
+		t.Errorf("unexpected discrepancies %+v", report.Discrepancies)
+	}
+	if item := report.Discrepancies[0]; item.StoredHash == "" || item.StoredHash == item.AnchoredHash || item.AnchorTxID != "tx-2" {
+		t.Errorf("expected the mismatch to carry both hashes, got %+v", item)
+	}
+
+	// Scoped to an incident, only its evidence and e-FIRs are checked
+	scoped := &IntegrityReport{}
+	checker.incidentID = "INC-1"
+	if err := checker.check(ctx, integrityKinds, scoped); err != nil {
+		t.Fatal(err)
+	}
+	if s := scoped.Summary; s.Checked != 4 || s.Verified != 2 {
+		t.Errorf("unexpected scoped summary %+v", s)
+	}
+}
+
+func TestSignIntegrityReport(t *testing.T) {
+	_, key, _ := ed25519.GenerateKey(nil)
+	signer := newQRSigner(key)
+	report := &IntegrityReport{ReportID: "INTEGRITY-20251001T120000Z", Issuer: "did:sih:gateway", Summary: IntegritySummary{Checked: 1, Mismatched: 1}}
+	signed, err := signIntegrityReport(signer, report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := signer.verifyJWT(signed, "did:sih:gateway#"+signer.keyID)
+	if err != nil {
+		t.Fatalf("expected the report signature to verify: %v", err)
+	}
+	if claims["report_id"] != report.ReportID || report.SigningKey["kid"] != "did:sih:gateway#"+signer.keyID {
+		t.Errorf("unexpected signed report %v", claims)
+	}
+}
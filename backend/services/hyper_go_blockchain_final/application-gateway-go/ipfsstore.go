@@ -0,0 +1,336 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// ContentAddressed is implemented by stores whose objects have a content identifier worth anchoring
+type ContentAddressed interface {
+	ContentID(ctx context.Context, key string) (string, error)
+}
+
+// ipfsStore writes evidence into the IPFS mutable file system of a Kubo node so objects stay
+// addressable by key, and pins each file's CID locally or through an IPFS Cluster
+type ipfsStore struct {
+	apiURL     string
+	clusterURL string
+	root       string
+	httpClient *http.Client
+}
+
+type ipfsStat struct {
+	Hash string `json:"Hash"`
+	Size int64  `json:"Size"`
+	Type string `json:"Type"`
+}
+
+type ipfsListing struct {
+	Entries []struct {
+		Name string `json:"Name"`
+		Type int    `json:"Type"`
+		Hash string `json:"Hash"`
+	} `json:"Entries"`
+}
+
+func newIPFSStoreFromEnv() *ipfsStore {
+	return &ipfsStore{
+		apiURL:     strings.TrimSuffix(getEnv("IPFS_API", "http://127.0.0.1:5001"), "/"),
+		clusterURL: strings.TrimSuffix(getEnv("IPFS_CLUSTER_API", ""), "/"),
+		root:       getEnv("IPFS_MFS_ROOT", "/sih"),
+		httpClient: &http.Client{Timeout: getEnvDuration("IPFS_TIMEOUT", 5*time.Minute)},
+	}
+}
+
+func (s *ipfsStore) mfsPath(key string) string {
+	return path.Join(s.root, path.Clean("/"+key))
+}
+
+// call invokes a Kubo RPC endpoint; every Kubo RPC is a POST
+func (s *ipfsStore) call(ctx context.Context, command string, params url.Values, body io.Reader, contentType string) (*http.Response, error) {
+	endpoint := s.apiURL + "/api/v0/" + command + "?" + params.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		if strings.Contains(string(message), "does not exist") || strings.Contains(string(message), "not pinned") {
+			return nil, errObjectNotFound
+		}
+		return nil, fmt.Errorf("ipfs %s failed with %s: %s", command, resp.Status, strings.TrimSpace(string(message)))
+	}
+	return resp, nil
+}
+
+func (s *ipfsStore) callJSON(ctx context.Context, command string, params url.Values, out interface{}) error {
+	resp, err := s.call(ctx, command, params, nil, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// Put streams the file into MFS as a multipart body and pins the resulting CID
+func (s *ipfsStore) Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error {
+	pipeReader, pipeWriter := io.Pipe()
+	form := multipart.NewWriter(pipeWriter)
+	go func() {
+		part, err := form.CreateFormFile("file", path.Base(key))
+		if err == nil {
+			_, err = io.Copy(part, body)
+		}
+		if err == nil {
+			err = form.Close()
+		}
+		pipeWriter.CloseWithError(err)
+	}()
+
+	params := url.Values{
+		"arg":         {s.mfsPath(key)},
+		"create":      {"true"},
+		"parents":     {"true"},
+		"truncate":    {"true"},
+		"cid-version": {"1"},
+	}
+	resp, err := s.call(ctx, "files/write", params, pipeReader, form.FormDataContentType())
+	if err != nil {
+		pipeReader.CloseWithError(err)
+		return err
+	}
+	resp.Body.Close()
+
+	cid, err := s.ContentID(ctx, key)
+	if err != nil {
+		return err
+	}
+	return s.pin(ctx, cid)
+}
+
+func (s *ipfsStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := s.call(ctx, "files/read", url.Values{"arg": {s.mfsPath(key)}}, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *ipfsStore) Delete(ctx context.Context, key string) error {
+	cid, err := s.ContentID(ctx, key)
+	if err == errObjectNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := s.callJSON(ctx, "files/rm", url.Values{"arg": {s.mfsPath(key)}, "force": {"true"}}, nil); err != nil {
+		return err
+	}
+	if err := s.unpin(ctx, cid); err != nil && err != errObjectNotFound {
+		return err
+	}
+	return nil
+}
+
+// ContentID returns the CID of the object stored under key
+func (s *ipfsStore) ContentID(ctx context.Context, key string) (string, error) {
+	var stat ipfsStat
+	if err := s.callJSON(ctx, "files/stat", url.Values{"arg": {s.mfsPath(key)}}, &stat); err != nil {
+		return "", err
+	}
+	return stat.Hash, nil
+}
+
+func (s *ipfsStore) pin(ctx context.Context, cid string) error {
+	if s.clusterURL != "" {
+		return s.clusterRequest(ctx, http.MethodPost, cid)
+	}
+	return s.callJSON(ctx, "pin/add", url.Values{"arg": {cid}}, nil)
+}
+
+func (s *ipfsStore) unpin(ctx context.Context, cid string) error {
+	if s.clusterURL != "" {
+		return s.clusterRequest(ctx, http.MethodDelete, cid)
+	}
+	return s.callJSON(ctx, "pin/rm", url.Values{"arg": {cid}}, nil)
+}
+
+func (s *ipfsStore) isPinned(ctx context.Context, cid string) (bool, error) {
+	if s.clusterURL != "" {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.clusterURL+"/pins/"+cid, nil)
+		if err != nil {
+			return false, err
+		}
+		resp, err := s.httpClient.Do(req)
+		if err != nil {
+			return false, err
+		}
+		defer resp.Body.Close()
+		var status struct {
+			PeerMap map[string]struct {
+				Status string `json:"status"`
+			} `json:"peer_map"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+			return false, err
+		}
+		for _, peer := range status.PeerMap {
+			if peer.Status == "pinned" {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+
+	err := s.callJSON(ctx, "pin/ls", url.Values{"arg": {cid}, "type": {"recursive"}}, nil)
+	if err == errObjectNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *ipfsStore) clusterRequest(ctx context.Context, method, cid string) error {
+	req, err := http.NewRequestWithContext(ctx, method, s.clusterURL+"/pins/"+cid, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return errObjectNotFound
+	}
+	if resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("ipfs cluster %s %s failed with %s: %s", method, cid, resp.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+// walk visits every file below dir in MFS
+func (s *ipfsStore) walk(ctx context.Context, dir string, visit func(key, cid string)) error {
+	var listing ipfsListing
+	if err := s.callJSON(ctx, "files/ls", url.Values{"arg": {dir}, "long": {"true"}}, &listing); err != nil {
+		return err
+	}
+	for _, entry := range listing.Entries {
+		full := path.Join(dir, entry.Name)
+		if entry.Type == 1 {
+			if err := s.walk(ctx, full, visit); err != nil {
+				return err
+			}
+			continue
+		}
+		visit(strings.TrimPrefix(full, s.root+"/"), entry.Hash)
+	}
+	return nil
+}
+
+// startIPFSVerifier periodically confirms every stored evidence file is still pinned and still
+// hashes to the value anchored on the ledger, re-pinning anything that has dropped out
+func startIPFSVerifier(ctx context.Context, store *ipfsStore) {
+	interval := getEnvDuration("IPFS_VERIFY_INTERVAL", time.Hour)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			verifyIPFSEvidence(ctx, store)
+		}
+	}
+}
+
+func verifyIPFSEvidence(ctx context.Context, store *ipfsStore) {
+	checked, failures := 0, 0
+	err := store.walk(ctx, path.Join(store.root, "evidence"), func(key, cid string) {
+		checked++
+		evidenceID := path.Base(key)
+
+		pinned, err := store.isPinned(ctx, cid)
+		if err != nil {
+			log.Printf("IPFS verify: failed to check pin for %s (%s): %v", evidenceID, cid, err)
+		} else if !pinned {
+			failures++
+			log.Printf("⚠️  IPFS verify: %s (%s) is no longer pinned, re-pinning", evidenceID, cid)
+			if err := store.pin(ctx, cid); err != nil {
+				log.Printf("IPFS verify: failed to re-pin %s: %v", cid, err)
+			}
+		}
+
+		result, err := evaluateTransaction(ctx, "ReadEvidence", evidenceID)
+		if err != nil {
+			log.Printf("IPFS verify: failed to read ledger record for %s: %v", evidenceID, err)
+			return
+		}
+		var evidence EvidenceDocument
+		if err := json.Unmarshal(result, &evidence); err != nil {
+			log.Printf("IPFS verify: failed to parse ledger record for %s: %v", evidenceID, err)
+			return
+		}
+
+		body, err := store.Get(ctx, key)
+		if err != nil {
+			failures++
+			log.Printf("⚠️  IPFS verify: failed to read %s: %v", key, err)
+			return
+		}
+		hasher := sha256.New()
+		_, err = io.Copy(hasher, body)
+		body.Close()
+		if err != nil {
+			log.Printf("IPFS verify: failed to hash %s: %v", key, err)
+			return
+		}
+
+		if hash := hex.EncodeToString(hasher.Sum(nil)); hash != evidence.EvidenceHash {
+			failures++
+			log.Printf("⚠️  IPFS verify: %s content hash %s does not match ledger hash %s", evidenceID, hash, evidence.EvidenceHash)
+		}
+		if evidence.CID != "" && evidence.CID != cid {
+			failures++
+			log.Printf("⚠️  IPFS verify: %s CID %s does not match ledger CID %s", evidenceID, cid, evidence.CID)
+		}
+	})
+	if err != nil && err != errObjectNotFound {
+		log.Printf("IPFS verify: failed to list stored evidence: %v", err)
+		return
+	}
+	log.Printf("🔍 IPFS verify: checked %d evidence files, %d problems", checked, failures)
+}
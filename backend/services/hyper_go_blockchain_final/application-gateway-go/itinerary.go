@@ -0,0 +1,534 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	itineraryPrefix       = "sih:itinerary:"
+	itineraryDeclaredKey  = "sih:itineraries"
+	maxItineraryStops     = 50
+	maxItineraryCorridor  = 50 // km
+	maxItineraryStopName  = 200
+	maxItineraryDuration  = 180 * 24 * time.Hour
+	itineraryExpiresAfter = 7 * 24 * time.Hour
+)
+
+// ItineraryStop is a place on a tourist's declared route. A stop with ArriveAt and DepartAt is a
+// stay point: between those times the tourist is expected within RadiusKm of it, rather than
+// anywhere along the route.
+type ItineraryStop struct {
+	Name      string   `json:"name"`
+	Latitude  *float64 `json:"latitude"`
+	Longitude *float64 `json:"longitude"`
+	ArriveAt  string   `json:"arriveAt,omitempty"`
+	DepartAt  string   `json:"departAt,omitempty"`
+	RadiusKm  float64  `json:"radiusKm,omitempty"`
+}
+
+// stayWindow returns the stop's stay window, if it is a stay point
+func (s ItineraryStop) stayWindow() (time.Time, time.Time, bool) {
+	if s.ArriveAt == "" {
+		return time.Time{}, time.Time{}, false
+	}
+	arrive, _ := time.Parse(time.RFC3339, s.ArriveAt)
+	depart, _ := time.Parse(time.RFC3339, s.DepartAt)
+	return arrive, depart, true
+}
+
+// ItineraryRequest declares the route a tourist plans to follow between startsAt and endsAt
+type ItineraryRequest struct {
+	Stops    []ItineraryStop `json:"stops" binding:"required"`
+	StartsAt string          `json:"startsAt" binding:"required"`
+	EndsAt   string          `json:"endsAt" binding:"required"`
+	// CorridorKm is how far from the route a position may be before it counts as a deviation
+	CorridorKm float64 `json:"corridorKm"`
+}
+
+func (r ItineraryRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	if len(r.Stops) < 2 || len(r.Stops) > maxItineraryStops {
+		v.add("stops", "must contain between 2 and %d stops", maxItineraryStops)
+	}
+	for i, stop := range r.Stops {
+		field := func(name string) string { return fmt.Sprintf("stops[%d].%s", i, name) }
+		if len(stop.Name) > maxItineraryStopName {
+			v.add(field("name"), "must be at most %d characters", maxItineraryStopName)
+		}
+		if stop.Latitude == nil || *stop.Latitude < -90 || *stop.Latitude > 90 {
+			v.add(field("latitude"), "must be between -90 and 90")
+		}
+		if stop.Longitude == nil || *stop.Longitude < -180 || *stop.Longitude > 180 {
+			v.add(field("longitude"), "must be between -180 and 180")
+		}
+		if stop.RadiusKm < 0 || stop.RadiusKm > maxItineraryCorridor {
+			v.add(field("radiusKm"), "must be between 0 and %d", maxItineraryCorridor)
+		}
+	}
+	start, startOK := v.rfc3339("startsAt", r.StartsAt)
+	end, endOK := v.rfc3339("endsAt", r.EndsAt)
+	if startOK && endOK && (!end.After(start) || end.Sub(start) > maxItineraryDuration) {
+		v.add("endsAt", "must be after startsAt and within %d days of it", int(maxItineraryDuration.Hours()/24))
+	}
+	for i, stop := range r.Stops {
+		if stop.ArriveAt == "" && stop.DepartAt == "" {
+			continue
+		}
+		field := func(name string) string { return fmt.Sprintf("stops[%d].%s", i, name) }
+		arrive, arriveOK := v.rfc3339(field("arriveAt"), stop.ArriveAt)
+		depart, departOK := v.rfc3339(field("departAt"), stop.DepartAt)
+		if arriveOK && departOK && !depart.After(arrive) {
+			v.add(field("departAt"), "must be after arriveAt")
+		}
+		if arriveOK && departOK && startOK && endOK && (arrive.Before(start) || depart.After(end)) {
+			v.add(field("arriveAt"), "must be within the itinerary's startsAt and endsAt")
+		}
+	}
+	if r.CorridorKm < 0 || r.CorridorKm > maxItineraryCorridor {
+		v.add("corridorKm", "must be between 0 and %d", maxItineraryCorridor)
+	}
+	return v.errors
+}
+
+// Itinerary is a declared route as stored. It stays off the ledger, like positions; only Digest is
+// anchored, under AnchorID.
+type Itinerary struct {
+	DigitalID  string          `json:"digital_id"`
+	Stops      []ItineraryStop `json:"stops"`
+	StartsAt   string          `json:"starts_at"`
+	EndsAt     string          `json:"ends_at"`
+	CorridorKm float64         `json:"corridor_km"`
+	Digest     string          `json:"digest,omitempty"`
+	AnchorID   string          `json:"anchor_id,omitempty"`
+	UpdatedAt  string          `json:"updated_at"`
+}
+
+// itineraryDigest is a SHA-256 over the fields that define the route, so the same declaration
+// always anchors the same digest
+func itineraryDigest(it Itinerary) string {
+	data, _ := json.Marshal(struct {
+		DigitalID  string          `json:"digital_id"`
+		Stops      []ItineraryStop `json:"stops"`
+		StartsAt   string          `json:"starts_at"`
+		EndsAt     string          `json:"ends_at"`
+		CorridorKm float64         `json:"corridor_km"`
+	}{it.DigitalID, it.Stops, it.StartsAt, it.EndsAt, it.CorridorKm})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// ItineraryAnchor is an itinerary digest as the chaincode stores it
+type ItineraryAnchor struct {
+	AnchorID   string `json:"anchor_id"`
+	DigitalID  string `json:"digital_id"`
+	Digest     string `json:"digest"`
+	StopCount  int    `json:"stop_count"`
+	StartsAt   string `json:"starts_at"`
+	EndsAt     string `json:"ends_at"`
+	AnchoredBy string `json:"anchored_by"`
+	AnchoredAt string `json:"anchored_at"`
+	OwnerOrg   string `json:"owner_org,omitempty"`
+	TxID       string `json:"tx_id"`
+}
+
+// ItineraryDeviation compares a tourist's live location with their itinerary
+type ItineraryDeviation struct {
+	DigitalID string `json:"digital_id"`
+	// Active is whether the itinerary covers the live location's time; the rest is only set if so
+	Active     bool    `json:"active"`
+	Deviated   bool    `json:"deviated"`
+	DistanceKm float64 `json:"distance_km"`
+	LimitKm    float64 `json:"limit_km"`
+	// ExpectedStop names the stay point the tourist should be at, if any
+	ExpectedStop     string  `json:"expected_stop,omitempty"`
+	DeviatedSince    string  `json:"deviated_since,omitempty"`
+	DeviationSeconds int64   `json:"deviation_seconds,omitempty"`
+	Latitude         float64 `json:"latitude"`
+	Longitude        float64 `json:"longitude"`
+	LocatedAt        string  `json:"located_at"`
+}
+
+// activeAt reports whether the itinerary covers t
+func (it *Itinerary) activeAt(t time.Time) bool {
+	start, _ := time.Parse(time.RFC3339, it.StartsAt)
+	end, _ := time.Parse(time.RFC3339, it.EndsAt)
+	return !t.Before(start) && !t.After(end)
+}
+
+// distanceKm is how far a position is from the nearest leg of the route, measured on a flat
+// projection around the position, which is accurate enough at corridor scale
+func (it *Itinerary) distanceKm(lat, lng float64) float64 {
+	kmPerLng := 111.32 * math.Cos(lat*math.Pi/180)
+	project := func(s ItineraryStop) (float64, float64) {
+		return (*s.Longitude - lng) * kmPerLng, (*s.Latitude - lat) * 110.57
+	}
+	best := math.Inf(1)
+	for i := 1; i < len(it.Stops); i++ {
+		ax, ay := project(it.Stops[i-1])
+		bx, by := project(it.Stops[i])
+		dx, dy := bx-ax, by-ay
+		t := 0.0
+		if length := dx*dx + dy*dy; length > 0 {
+			t = math.Max(0, math.Min(1, -(ax*dx+ay*dy)/length))
+		}
+		best = math.Min(best, math.Hypot(ax+t*dx, ay+t*dy))
+	}
+	return best
+}
+
+// deviation is how far a position at t is from where the itinerary expects the tourist, and the
+// distance allowed. During a stay window that is the stay point; otherwise it is the route.
+func (it *Itinerary) deviation(lat, lng float64, t time.Time) (km, limitKm float64, stop string) {
+	for i, s := range it.Stops {
+		if arrive, depart, ok := s.stayWindow(); ok && !t.Before(arrive) && !t.After(depart) {
+			limitKm = s.RadiusKm
+			if limitKm == 0 {
+				limitKm = it.CorridorKm
+			}
+			name := s.Name
+			if name == "" {
+				name = fmt.Sprintf("stop %d", i+1)
+			}
+			return haversineKm(*s.Latitude, *s.Longitude, lat, lng), limitKm, name
+		}
+	}
+	return it.distanceKm(lat, lng), it.CorridorKm, ""
+}
+
+// upcomingIn returns the first stop inside an area that the tourist is still due to reach by
+// horizon after now: a stay point they arrive at by then and have not left, or any other stop of
+// a route under way or starting by then
+func (it *Itinerary) upcomingIn(contains func(lat, lng float64) bool, now time.Time, horizon time.Duration) (ItineraryStop, bool) {
+	start, _ := time.Parse(time.RFC3339, it.StartsAt)
+	end, _ := time.Parse(time.RFC3339, it.EndsAt)
+	routeDue := now.Before(end) && !start.After(now.Add(horizon))
+	for _, s := range it.Stops {
+		if !contains(*s.Latitude, *s.Longitude) {
+			continue
+		}
+		if arrive, depart, ok := s.stayWindow(); ok {
+			if now.Before(depart) && !arrive.After(now.Add(horizon)) {
+				return s, true
+			}
+			continue
+		}
+		if routeDue {
+			return s, true
+		}
+	}
+	return ItineraryStop{}, false
+}
+
+// itineraryStore keeps declared itineraries until a week after they end
+type itineraryStore interface {
+	get(ctx context.Context, digitalID string) (*Itinerary, error)
+	put(ctx context.Context, it Itinerary, ttl time.Duration) error
+	remove(ctx context.Context, digitalID string) error
+	// list returns every unexpired itinerary
+	list(ctx context.Context) ([]Itinerary, error)
+}
+
+type redisItineraryStore struct {
+	redis *redisClient
+}
+
+func (s redisItineraryStore) get(ctx context.Context, digitalID string) (*Itinerary, error) {
+	data, err := s.redis.Get(ctx, itineraryPrefix+digitalID)
+	if errors.Is(err, errRedisNil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var it Itinerary
+	if err := json.Unmarshal(data, &it); err != nil {
+		return nil, err
+	}
+	return &it, nil
+}
+
+func (s redisItineraryStore) put(ctx context.Context, it Itinerary, ttl time.Duration) error {
+	data, err := json.Marshal(it)
+	if err != nil {
+		return err
+	}
+	if err := s.redis.Set(ctx, itineraryPrefix+it.DigitalID, data, ttl); err != nil {
+		return err
+	}
+	_, err = s.redis.Do(ctx, "SADD", itineraryDeclaredKey, it.DigitalID)
+	return err
+}
+
+func (s redisItineraryStore) remove(ctx context.Context, digitalID string) error {
+	if err := s.redis.Del(ctx, itineraryPrefix+digitalID); err != nil {
+		return err
+	}
+	_, err := s.redis.Do(ctx, "SREM", itineraryDeclaredKey, digitalID)
+	return err
+}
+
+// list reads the set of declared tourists, which puts only add to, and drops those whose
+// itinerary has expired
+func (s redisItineraryStore) list(ctx context.Context) ([]Itinerary, error) {
+	reply, err := s.redis.Do(ctx, "SMEMBERS", itineraryDeclaredKey)
+	if err != nil {
+		return nil, err
+	}
+	members, _ := reply.([]interface{})
+	var itineraries []Itinerary
+	for _, member := range members {
+		raw, _ := member.([]byte)
+		it, err := s.get(ctx, string(raw))
+		if err != nil {
+			return nil, err
+		}
+		if it == nil {
+			s.redis.Do(ctx, "SREM", itineraryDeclaredKey, string(raw))
+			continue
+		}
+		itineraries = append(itineraries, *it)
+	}
+	return itineraries, nil
+}
+
+// memoryItineraryStore serves a single gateway instance
+type memoryItineraryStore struct {
+	mu          sync.Mutex
+	itineraries map[string]Itinerary
+	expires     map[string]time.Time
+}
+
+func newMemoryItineraryStore() *memoryItineraryStore {
+	return &memoryItineraryStore{itineraries: map[string]Itinerary{}, expires: map[string]time.Time{}}
+}
+
+func (s *memoryItineraryStore) get(_ context.Context, digitalID string) (*Itinerary, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	it, ok := s.itineraries[digitalID]
+	if !ok || time.Now().After(s.expires[digitalID]) {
+		return nil, nil
+	}
+	return &it, nil
+}
+
+func (s *memoryItineraryStore) put(_ context.Context, it Itinerary, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.itineraries[it.DigitalID], s.expires[it.DigitalID] = it, time.Now().Add(ttl)
+	return nil
+}
+
+func (s *memoryItineraryStore) remove(_ context.Context, digitalID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.itineraries, digitalID)
+	delete(s.expires, digitalID)
+	return nil
+}
+
+func (s *memoryItineraryStore) list(_ context.Context) ([]Itinerary, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var itineraries []Itinerary
+	for _, digitalID := range sortedKeys(s.itineraries) {
+		if time.Now().Before(s.expires[digitalID]) {
+			itineraries = append(itineraries, s.itineraries[digitalID])
+		}
+	}
+	return itineraries, nil
+}
+
+func itineraryTourist(c *gin.Context) (string, bool) {
+	if anomalies == nil {
+		respondError(c, http.StatusServiceUnavailable, errCodeAnomaliesDisabled, "Anomaly detection is not enabled")
+		return "", false
+	}
+	digitalID := c.Param("digitalId")
+	var v fieldValidator
+	if v.digitalID("digitalId", digitalID); len(v.errors) > 0 {
+		respondValidationErrors(c, v.errors)
+		return "", false
+	}
+	return digitalID, true
+}
+
+func putItinerary(c *gin.Context) {
+	digitalID, ok := itineraryTourist(c)
+	if !ok {
+		return
+	}
+	var req ItineraryRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+	it := Itinerary{DigitalID: digitalID, Stops: req.Stops, CorridorKm: req.CorridorKm, UpdatedAt: time.Now().UTC().Format(time.RFC3339)}
+	start, _ := time.Parse(time.RFC3339, req.StartsAt)
+	end, _ := time.Parse(time.RFC3339, req.EndsAt)
+	it.StartsAt, it.EndsAt = start.UTC().Format(time.RFC3339), end.UTC().Format(time.RFC3339)
+	if it.CorridorKm == 0 {
+		it.CorridorKm = anomalies.corridorKm
+	}
+	ttl := time.Until(end) + itineraryExpiresAfter
+	if ttl <= 0 {
+		respondError(c, http.StatusBadRequest, errCodeValidation, "The itinerary ended more than a week ago")
+		return
+	}
+	setAuditTarget(c, digitalID)
+
+	result, err := ledger.AnchorItinerary(c.Request.Context(), &it)
+	if err != nil {
+		respondServiceError(c, "Failed to anchor itinerary", err)
+		return
+	}
+	if err := anomalies.itineraries.put(c.Request.Context(), it, ttl); err != nil {
+		respondServiceError(c, "Failed to save itinerary", err)
+		return
+	}
+	respondCommitted(c, http.StatusOK, it, result)
+}
+
+// AnchorItinerary sets the itinerary's digest and anchors it. Declaring an itinerary again
+// unchanged finds it already anchored and returns no transaction.
+func (ledgerService) AnchorItinerary(ctx context.Context, it *Itinerary) (*TransactionResult, error) {
+	it.Digest = itineraryDigest(*it)
+	it.AnchorID = "ITIN-" + it.Digest[:32]
+	result, err := submitTransaction(ctx, "AnchorItinerary", it.AnchorID, it.DigitalID, it.Digest,
+		strconv.Itoa(len(it.Stops)), it.StartsAt, it.EndsAt, it.DigitalID)
+	if err != nil && translateFabricError(err).Code == errCodeAlreadyExists {
+		return nil, nil
+	}
+	return result, err
+}
+
+func (ledgerService) ItineraryAnchors(ctx context.Context, digitalID string) ([]ItineraryAnchor, error) {
+	result, err := evaluateTransaction(ctx, "GetItineraryAnchors", digitalID)
+	if err != nil {
+		return nil, err
+	}
+	return decodeDocument[[]ItineraryAnchor](result, "itinerary anchor")
+}
+
+// deviation compares the live location with the itinerary. DeviatedSince comes from the anomaly
+// detector, which tracks each deviation from its first ping.
+func (d *anomalyDetector) deviation(ctx context.Context, it *Itinerary, live *LiveLocation) (ItineraryDeviation, error) {
+	result := ItineraryDeviation{DigitalID: it.DigitalID, Latitude: live.Latitude, Longitude: live.Longitude, LocatedAt: live.RecordedAt}
+	at, _ := time.Parse(time.RFC3339, live.RecordedAt)
+	if !it.activeAt(at) {
+		return result, nil
+	}
+	km, limit, stop := it.deviation(live.Latitude, live.Longitude, at)
+	result.Active, result.Deviated, result.LimitKm, result.ExpectedStop = true, km > limit, limit, stop
+	result.DistanceKm = math.Round(km*100) / 100
+	if !result.Deviated {
+		return result, nil
+	}
+	state, err := d.store.load(ctx, it.DigitalID)
+	if err != nil {
+		return result, err
+	}
+	if state != nil && state.DeviatedSince != "" {
+		since, _ := time.Parse(time.RFC3339, state.DeviatedSince)
+		result.DeviatedSince, result.DeviationSeconds = state.DeviatedSince, int64(at.Sub(since).Seconds())
+	}
+	return result, nil
+}
+
+func getItinerary(c *gin.Context) {
+	digitalID, ok := itineraryTourist(c)
+	if !ok {
+		return
+	}
+	it, err := anomalies.itineraries.get(c.Request.Context(), digitalID)
+	if err != nil {
+		respondServiceError(c, "Failed to read itinerary", err)
+		return
+	}
+	if it == nil {
+		respondError(c, http.StatusNotFound, errCodeNotFound, "No itinerary declared for this tourist")
+		return
+	}
+	respondData(c, http.StatusOK, it)
+}
+
+// getItineraryDeviation reports how far the tourist's live location is from their itinerary
+func getItineraryDeviation(c *gin.Context) {
+	digitalID, ok := itineraryTourist(c)
+	if !ok {
+		return
+	}
+	if locations == nil {
+		respondError(c, http.StatusNotFound, errCodeNotFound, "No live location for this tourist")
+		return
+	}
+	ctx := c.Request.Context()
+	it, err := anomalies.itineraries.get(ctx, digitalID)
+	if err != nil {
+		respondServiceError(c, "Failed to read itinerary", err)
+		return
+	}
+	if it == nil {
+		respondError(c, http.StatusNotFound, errCodeNotFound, "No itinerary declared for this tourist")
+		return
+	}
+	live, err := locations.store.latest(ctx, digitalID)
+	if err != nil {
+		respondServiceError(c, "Failed to read live location", err)
+		return
+	}
+	if live == nil {
+		respondError(c, http.StatusNotFound, errCodeNotFound, "No live location for this tourist")
+		return
+	}
+
+	deviation, err := anomalies.deviation(ctx, it, live)
+	if err != nil {
+		respondServiceError(c, "Failed to compare itinerary", err)
+		return
+	}
+	respondData(c, http.StatusOK, deviation)
+}
+
+// listItineraryAnchors lists the itinerary digests anchored for a tourist
+func listItineraryAnchors(c *gin.Context) {
+	digitalID, ok := itineraryTourist(c)
+	if !ok {
+		return
+	}
+	anchors, err := ledger.ItineraryAnchors(c.Request.Context(), digitalID)
+	if err != nil {
+		respondServiceError(c, "Failed to list itinerary anchors", err)
+		return
+	}
+	respondData(c, http.StatusOK, anchors)
+}
+
+func deleteItinerary(c *gin.Context) {
+	digitalID, ok := itineraryTourist(c)
+	if !ok {
+		return
+	}
+	if err := anomalies.itineraries.remove(c.Request.Context(), digitalID); err != nil {
+		respondServiceError(c, "Failed to delete itinerary", err)
+		return
+	}
+	respondData(c, http.StatusOK, gin.H{"message": "Itinerary deleted successfully"})
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// kafkaRecord is one message to produce
+type kafkaRecord struct {
+	key       []byte
+	value     []byte
+	timestamp time.Time
+}
+
+// kafkaProducer publishes events with franz-go, acknowledged by every in-sync replica. Records are
+// placed by key with the Java client's murmur2 partitioner, so they land where other producers
+// would put them.
+type kafkaProducer struct {
+	client  *kgo.Client
+	timeout time.Duration
+}
+
+// newKafkaProducer connects lazily to the bootstrap brokers; opts add TLS or SASL
+func newKafkaProducer(bootstrap []string, clientID string, timeout time.Duration, opts ...kgo.Opt) (*kafkaProducer, error) {
+	client, err := kgo.NewClient(append([]kgo.Opt{
+		kgo.SeedBrokers(bootstrap...),
+		kgo.ClientID(clientID),
+		kgo.RequiredAcks(kgo.AllISRAcks()),
+		kgo.RecordPartitioner(kgo.StickyKeyPartitioner(nil)),
+		// Event documents are small JSON, so batches are left uncompressed for any consumer to read
+		kgo.ProducerBatchCompression(kgo.NoCompression()),
+		kgo.DialTimeout(timeout),
+		kgo.ProduceRequestTimeout(timeout),
+	}, opts...)...)
+	if err != nil {
+		return nil, err
+	}
+	return &kafkaProducer{client: client, timeout: timeout}, nil
+}
+
+// produce appends records to topic and waits for every in-sync replica to have them. On failure
+// some partitions may have been written, so a retry can duplicate records.
+func (p *kafkaProducer) produce(ctx context.Context, topic string, records []kafkaRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+	// franz-go's delivery timeout counts from each record's timestamp, which is the block's time
+	// when replaying, so the wait is bounded here instead
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	batch := make([]*kgo.Record, len(records))
+	for i, record := range records {
+		batch[i] = &kgo.Record{Topic: topic, Key: record.key, Value: record.value, Timestamp: record.timestamp}
+	}
+	return p.client.ProduceSync(ctx, batch...).FirstErr()
+}
+
+// close releases every connection
+func (p *kafkaProducer) close() {
+	p.client.Close()
+}
@@ -0,0 +1,268 @@
+package gateway
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+// producedRecord is a record the fake broker decoded from a Produce request
+type producedRecord struct {
+	topic     string
+	partition int32
+	key       string
+	value     []byte
+}
+
+// fakeKafkaBroker answers ApiVersions, Metadata, InitProducerID and Produce for one topic with the
+// given number of partitions, all led by itself, and reports the records it was sent
+func fakeKafkaBroker(t *testing.T, topic string, partitions int32, produced chan<- producedRecord) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	host, portText, _ := net.SplitHostPort(listener.Addr().String())
+	port, _ := strconv.Atoi(portText)
+
+	respond := func(req kmsg.Request) kmsg.Response {
+		switch req := req.(type) {
+		case *kmsg.ApiVersionsRequest:
+			resp := kmsg.NewPtrApiVersionsResponse()
+			for _, key := range []kmsg.ApiVersionsResponseApiKey{{ApiKey: 0, MaxVersion: 8}, {ApiKey: 3, MaxVersion: 8}, {ApiKey: 18, MaxVersion: 3}, {ApiKey: 22, MaxVersion: 1}} {
+				resp.ApiKeys = append(resp.ApiKeys, key)
+			}
+			return resp
+		case *kmsg.MetadataRequest:
+			resp := kmsg.NewPtrMetadataResponse()
+			resp.Brokers = []kmsg.MetadataResponseBroker{{NodeID: 1, Host: host, Port: int32(port)}}
+			for _, requested := range req.Topics {
+				metadata := kmsg.NewMetadataResponseTopic()
+				metadata.Topic = requested.Topic
+				if requested.Topic == nil || *requested.Topic != topic {
+					metadata.ErrorCode = 3 // UNKNOWN_TOPIC_OR_PARTITION
+				}
+				for p := int32(0); metadata.ErrorCode == 0 && p < partitions; p++ {
+					metadata.Partitions = append(metadata.Partitions, kmsg.MetadataResponseTopicPartition{Partition: p, Leader: 1, Replicas: []int32{1}, ISR: []int32{1}})
+				}
+				resp.Topics = append(resp.Topics, metadata)
+			}
+			return resp
+		case *kmsg.InitProducerIDRequest:
+			resp := kmsg.NewPtrInitProducerIDResponse()
+			resp.ProducerID = 1
+			return resp
+		case *kmsg.ProduceRequest:
+			if req.Acks != -1 {
+				t.Errorf("expected acks from all replicas, got %d", req.Acks)
+			}
+			resp := kmsg.NewPtrProduceResponse()
+			for _, produce := range req.Topics {
+				answer := kmsg.NewProduceResponseTopic()
+				answer.Topic = produce.Topic
+				for _, partition := range produce.Partitions {
+					for _, record := range decodeTestBatch(t, partition.Records) {
+						record.topic, record.partition = produce.Topic, partition.Partition
+						produced <- record
+					}
+					answer.Partitions = append(answer.Partitions, kmsg.ProduceResponseTopicPartition{Partition: partition.Partition})
+				}
+				resp.Topics = append(resp.Topics, answer)
+			}
+			return resp
+		}
+		t.Errorf("unexpected request %T", req)
+		return nil
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				for {
+					var size [4]byte
+					if _, err := io.ReadFull(reader, size[:]); err != nil {
+						return
+					}
+					frame := make([]byte, binary.BigEndian.Uint32(size[:]))
+					if _, err := io.ReadFull(reader, frame); err != nil {
+						return
+					}
+					// Request header: key, version, correlation ID, client ID and, when flexible, no tags
+					key, version := int16(binary.BigEndian.Uint16(frame)), int16(binary.BigEndian.Uint16(frame[2:]))
+					correlation := frame[4:8]
+					body := frame[10+int(int16(binary.BigEndian.Uint16(frame[8:]))):]
+					req := kmsg.RequestForKey(key)
+					if req == nil {
+						t.Errorf("unexpected API %d", key)
+						return
+					}
+					req.SetVersion(version)
+					if req.IsFlexible() {
+						body = body[1:]
+					}
+					if err := req.ReadFrom(body); err != nil {
+						t.Errorf("malformed API %d v%d request: %v", key, version, err)
+						return
+					}
+					resp := respond(req)
+					if resp == nil {
+						return
+					}
+					resp.SetVersion(version)
+					out := append([]byte{0, 0, 0, 0}, correlation...)
+					// ApiVersions responses keep the old header, so clients can read them at any version
+					if resp.IsFlexible() && key != 18 {
+						out = append(out, 0)
+					}
+					out = resp.AppendTo(out)
+					binary.BigEndian.PutUint32(out, uint32(len(out)-4))
+					conn.Write(out)
+				}
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// decodeTestBatch checks an uncompressed v2 record batch and returns its records
+func decodeTestBatch(t *testing.T, raw []byte) []producedRecord {
+	var batch kmsg.RecordBatch
+	if err := batch.ReadFrom(raw); err != nil {
+		t.Errorf("malformed record batch: %v", err)
+		return nil
+	}
+	if batch.Magic != 2 || batch.Attributes&0x07 != 0 {
+		t.Errorf("expected an uncompressed v2 batch, got magic %d attributes %x", batch.Magic, batch.Attributes)
+	}
+	if crc := uint32(batch.CRC); crc != crc32.Checksum(raw[21:], crc32.MakeTable(crc32.Castagnoli)) {
+		t.Error("record batch CRC does not match")
+	}
+
+	var records []producedRecord
+	rest := batch.Records
+	for i := int32(0); i < batch.NumRecords; i++ {
+		length, n := binary.Varint(rest)
+		if n <= 0 || int(length)+n > len(rest) {
+			t.Errorf("malformed record %d", i)
+			return records
+		}
+		var record kmsg.Record
+		if err := record.ReadFrom(rest[:n+int(length)]); err != nil {
+			t.Errorf("malformed record %d: %v", i, err)
+			return records
+		}
+		rest = rest[n+int(length):]
+		records = append(records, producedRecord{key: string(record.Key), value: record.Value})
+	}
+	if len(rest) != 0 {
+		t.Errorf("%d bytes left after the batch's records", len(rest))
+	}
+	return records
+}
+
+func TestKafkaProducer(t *testing.T) {
+	produced := make(chan producedRecord, 10)
+	addr := fakeKafkaBroker(t, "incident.created", 3, produced)
+	p, err := newKafkaProducer([]string{addr}, "test", 2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.close()
+
+	now := time.Now()
+	records := []kafkaRecord{
+		{key: []byte("inc_1"), value: []byte(`{"n":1}`), timestamp: now},
+		{key: []byte("inc_2"), value: []byte(`{"n":2}`), timestamp: now.Add(time.Second)},
+		{key: []byte("inc_1"), value: []byte(`{"n":3}`), timestamp: now.Add(2 * time.Second)},
+	}
+	if err := p.produce(context.Background(), "incident.created", records); err != nil {
+		t.Fatal(err)
+	}
+
+	byKey := map[string][]producedRecord{}
+	for range records {
+		record := <-produced
+		byKey[record.key] = append(byKey[record.key], record)
+	}
+	first := byKey["inc_1"]
+	if len(first) != 2 || string(first[0].value) != `{"n":1}` || string(first[1].value) != `{"n":3}` {
+		t.Fatalf("expected inc_1's records in order, got %+v", first)
+	}
+	// The Java client puts inc_1 on partition 2 of 3, and inc_2 on partition 1
+	if first[0].partition != 2 || first[1].partition != 2 || byKey["inc_2"][0].partition != 1 {
+		t.Errorf("expected the Java client's partitions, got %+v", byKey)
+	}
+
+	if err := p.produce(context.Background(), "sos.raised", records); err == nil {
+		t.Error("expected a topic the broker does not report to fail")
+	}
+}
+
+func TestKafkaBridgePublish(t *testing.T) {
+	var subject, schema string
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		subject, schema = strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/subjects/"), "/versions"), body["schema"]
+		w.Write([]byte(`{"id":42}`))
+	}))
+	defer registry.Close()
+
+	produced := make(chan producedRecord, 10)
+	addr := fakeKafkaBroker(t, "sih.incident.created", 1, produced)
+	schemaRegistry, _ := newSchemaRegistry(registry.URL, time.Second)
+	producer, err := newKafkaProducer([]string{addr}, "test", 2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bridge := &kafkaBridge{producer: producer, prefix: "sih.", registry: schemaRegistry, schemaIDs: map[string]int32{}}
+	defer bridge.producer.close()
+
+	tx := blockTransaction{TxID: "tx1", Timestamp: time.Date(2025, 9, 20, 13, 19, 10, 0, time.UTC), Events: []*peer.ChaincodeEvent{
+		{EventName: "CreateIncident", Payload: []byte(`{"doc_type":"incident","incident_id":"inc_1","status":"open"}`)},
+		{EventName: "RecordEFIR", Payload: []byte(`{"fir_id":"FIR-1"}`)},
+	}}
+	target := &fabricTarget{Channel: "mychannel", Chaincode: "sihcc"}
+	if err := bridge.publish(context.Background(), target, 9, []blockTransaction{tx}); err != nil {
+		t.Fatal(err)
+	}
+
+	record := <-produced
+	if subject != "sih.incident.created-value" || !strings.Contains(schema, `"#/definitions/IncidentDocument"`) {
+		t.Errorf("expected the topic's schema registered, got %s: %s", subject, schema)
+	}
+	if record.key != "inc_1" || record.value[0] != 0 || binary.BigEndian.Uint32(record.value[1:5]) != 42 {
+		t.Fatalf("expected a record framed with schema 42, got %q %x", record.key, record.value[:5])
+	}
+	var event BridgeEvent
+	if err := json.Unmarshal(record.value[5:], &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.EventID != "tx1:0" || event.Type != "incident.created" || event.BlockNumber != 9 || !strings.Contains(string(event.Data), `"incident_id":"inc_1"`) {
+		t.Errorf("unexpected event %+v", event)
+	}
+	select {
+	case extra := <-produced:
+		t.Errorf("expected unbridged events skipped, got %+v", extra)
+	default:
+	}
+}
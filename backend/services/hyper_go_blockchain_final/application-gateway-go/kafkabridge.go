@@ -0,0 +1,264 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/sasl/plain"
+)
+
+// BridgeEvent is the value of every record the Kafka bridge publishes. EventID is unique per
+// chaincode event; the bridge delivers at least once, so consumers deduplicate on it.
+type BridgeEvent struct {
+	EventID     string          `json:"event_id" binding:"required"`
+	Type        string          `json:"type" binding:"required"`
+	Channel     string          `json:"channel" binding:"required"`
+	Chaincode   string          `json:"chaincode" binding:"required"`
+	TxID        string          `json:"tx_id" binding:"required"`
+	BlockNumber uint64          `json:"block_number" binding:"required"`
+	Timestamp   string          `json:"timestamp" binding:"required"`
+	Data        json.RawMessage `json:"data" binding:"required"`
+}
+
+// bridgeTopic maps chaincode events to a Kafka topic. encode returns the record key, which keeps
+// one document's records in order on a partition, and the data of the event.
+type bridgeTopic struct {
+	name   string
+	events []string
+	doc    reflect.Type
+	encode func(payload []byte) (string, []byte, error)
+}
+
+var bridgeTopics = []bridgeTopic{
+	{"incident.created", []string{"CreateIncident"}, reflect.TypeOf(IncidentDocument{}),
+		bridgeDocument("incident event", func(d IncidentDocument) string { return d.IncidentID })},
+	{"incident.status_changed", []string{"UpdateIncidentStatus"}, reflect.TypeOf(IncidentDocument{}),
+		bridgeDocument("incident event", func(d IncidentDocument) string { return d.IncidentID })},
+	{"evidence.anchored", []string{"CreateEvidence"}, reflect.TypeOf(EvidenceDocument{}),
+		bridgeDocument("evidence event", func(d EvidenceDocument) string { return d.EvidenceID })},
+	{"sos.raised", []string{"RaiseSOS"}, reflect.TypeOf(SOSDocument{}),
+		bridgeDocument("SOS event", func(d SOSDocument) string { return d.AlertID })},
+}
+
+// bridgeDocument decodes an event payload as T and re-encodes it, so the data matches the schema
+func bridgeDocument[T any](kind string, key func(T) string) func([]byte) (string, []byte, error) {
+	return func(payload []byte) (string, []byte, error) {
+		doc, err := decodeDocument[T](payload, kind)
+		if err != nil {
+			return "", nil, err
+		}
+		data, err := json.Marshal(doc)
+		return key(doc), data, err
+	}
+}
+
+// kafkaBridge republishes chaincode events from the block indexer to Kafka
+type kafkaBridge struct {
+	producer *kafkaProducer
+	prefix   string
+	registry *schemaRegistry
+	// schemaIDs caches the registered schema of each topic
+	schemaIDs map[string]int32
+}
+
+// newKafkaBridgeFromEnv configures the bridge from KAFKA_*, or returns nil when KAFKA_BROKERS is
+// not set
+func newKafkaBridgeFromEnv() *kafkaBridge {
+	brokers := getEnv("KAFKA_BROKERS", "")
+	if brokers == "" {
+		log.Println("📤 KAFKA_BROKERS not set, Kafka bridge disabled")
+		return nil
+	}
+	var bootstrap []string
+	for _, broker := range strings.Split(brokers, ",") {
+		if broker = strings.TrimSpace(broker); broker != "" {
+			bootstrap = append(bootstrap, broker)
+		}
+	}
+	var opts []kgo.Opt
+	if getEnvBool("KAFKA_TLS", false) {
+		opts = append(opts, kgo.DialTLSConfig(&tls.Config{MinVersion: tls.VersionTLS12}))
+	}
+	if username := getEnv("KAFKA_SASL_USERNAME", ""); username != "" {
+		opts = append(opts, kgo.SASL(plain.Auth{User: username, Pass: getEnv("KAFKA_SASL_PASSWORD", "")}.AsMechanism()))
+	}
+	producer, err := newKafkaProducer(bootstrap, getEnv("KAFKA_CLIENT_ID", "sih-indexer"), getEnvDuration("KAFKA_TIMEOUT", 10*time.Second), opts...)
+	if err != nil {
+		panic(fmt.Errorf("failed to configure the Kafka bridge: %w", err))
+	}
+
+	bridge := &kafkaBridge{producer: producer, prefix: getEnv("KAFKA_TOPIC_PREFIX", ""), schemaIDs: map[string]int32{}}
+	if rawURL := getEnv("KAFKA_SCHEMA_REGISTRY_URL", ""); rawURL != "" {
+		registry, err := newSchemaRegistry(rawURL, producer.timeout)
+		if err != nil {
+			panic(fmt.Errorf("failed to configure schema registry: %w", err))
+		}
+		bridge.registry = registry
+	}
+	log.Printf("📤 Kafka bridge publishing to %s with topic prefix %q", strings.Join(bootstrap, ","), bridge.prefix)
+	return bridge
+}
+
+// publish sends the bridged events of a block's transactions. Events whose payload cannot be
+// decoded are logged and skipped rather than holding up the index.
+func (b *kafkaBridge) publish(ctx context.Context, target *fabricTarget, block uint64, transactions []blockTransaction) error {
+	records := map[string][]kafkaRecord{}
+	for _, tx := range transactions {
+		for i, event := range tx.Events {
+			topic, ok := bridgeTopicFor(event.GetEventName())
+			if !ok {
+				continue
+			}
+			record, err := b.record(ctx, topic, target, block, tx, i, event)
+			if err != nil {
+				var malformed *malformedDocumentError
+				if errors.As(err, &malformed) {
+					log.Printf("📤 Skipping %s in %s: %v", event.GetEventName(), tx.TxID, err)
+					continue
+				}
+				return err
+			}
+			records[topic.name] = append(records[topic.name], record)
+		}
+	}
+	for _, topic := range bridgeTopics {
+		if err := b.producer.produce(ctx, b.prefix+topic.name, records[topic.name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func bridgeTopicFor(eventName string) (bridgeTopic, bool) {
+	for _, topic := range bridgeTopics {
+		for _, name := range topic.events {
+			if name == eventName {
+				return topic, true
+			}
+		}
+	}
+	return bridgeTopic{}, false
+}
+
+// record encodes one event. With a schema registry the value carries the Confluent wire format
+// header: a zero byte and the big-endian schema ID.
+func (b *kafkaBridge) record(ctx context.Context, topic bridgeTopic, target *fabricTarget, block uint64, tx blockTransaction, index int, event *peer.ChaincodeEvent) (kafkaRecord, error) {
+	key, data, err := topic.encode(event.GetPayload())
+	if err != nil {
+		return kafkaRecord{}, err
+	}
+	value, err := json.Marshal(BridgeEvent{
+		EventID:     fmt.Sprintf("%s:%d", tx.TxID, index),
+		Type:        topic.name,
+		Channel:     target.Channel,
+		Chaincode:   target.Chaincode,
+		TxID:        tx.TxID,
+		BlockNumber: block,
+		Timestamp:   tx.Timestamp.UTC().Format(time.RFC3339Nano),
+		Data:        data,
+	})
+	if err != nil {
+		return kafkaRecord{}, err
+	}
+
+	if b.registry != nil {
+		id, ok := b.schemaIDs[topic.name]
+		if !ok {
+			if id, err = b.registry.register(ctx, b.prefix+topic.name+"-value", bridgeSchema(topic)); err != nil {
+				return kafkaRecord{}, fmt.Errorf("failed to register the %s schema: %w", topic.name, err)
+			}
+			b.schemaIDs[topic.name] = id
+		}
+		framed := make([]byte, 5, 5+len(value))
+		binary.BigEndian.PutUint32(framed[1:], uint32(id))
+		value = append(framed, value...)
+	}
+	return kafkaRecord{key: []byte(key), value: value, timestamp: tx.Timestamp}, nil
+}
+
+// bridgeSchema is the JSON Schema of a topic's records, generated like the OpenAPI components
+func bridgeSchema(topic bridgeTopic) string {
+	definitions := map[string]interface{}{}
+	schema := schemaFor(definitions, reflect.TypeOf(BridgeEvent{}))
+	schema["properties"].(map[string]interface{})["data"] = schemaRef(definitions, topic.doc)
+	schema["$schema"] = "http://json-schema.org/draft-07/schema#"
+	schema["title"] = topic.name
+	schema["definitions"] = definitions
+	data, _ := json.Marshal(schema)
+	return strings.ReplaceAll(string(data), "#/components/schemas/", "#/definitions/")
+}
+
+// schemaRegistry registers JSON schemas with a Confluent-compatible schema registry
+type schemaRegistry struct {
+	baseURL  string
+	username string
+	password string
+	client   *http.Client
+}
+
+// newSchemaRegistry parses an http(s)://[user:password@]host[:port] URL
+func newSchemaRegistry(rawURL string, timeout time.Duration) (*schemaRegistry, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schema registry URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported schema registry URL scheme %q", u.Scheme)
+	}
+	registry := &schemaRegistry{client: &http.Client{Timeout: timeout}}
+	if u.User != nil {
+		registry.username = u.User.Username()
+		registry.password, _ = u.User.Password()
+		u.User = nil
+	}
+	registry.baseURL = strings.TrimSuffix(u.String(), "/")
+	return registry, nil
+}
+
+// register returns the ID of schema under subject, adding it as a new version if it has changed.
+// The registry answers with the existing ID when the schema is already registered.
+func (r *schemaRegistry) register(ctx context.Context, subject, schema string) (int32, error) {
+	body, _ := json.Marshal(map[string]string{"schemaType": "JSON", "schema": schema})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.baseURL+"/subjects/"+url.PathEscape(subject)+"/versions", bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/vnd.schemaregistry.v1+json")
+	if r.username != "" {
+		req.SetBasicAuth(r.username, r.password)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		ID      int32  `json:"id"`
+		Message string `json:"message"`
+	}
+	json.NewDecoder(resp.Body).Decode(&result)
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("schema registry answered %d: %s", resp.StatusCode, result.Message)
+	}
+	return result.ID, nil
+}
@@ -0,0 +1,370 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// maxMigrationBatch is the most documents one MigrateLegacyDocuments transaction accepts
+const maxMigrationBatch = 100
+
+// LegacyDocument is a document still stored under the raw ID a v2 contract gave it
+type LegacyDocument struct {
+	Key     string `json:"key"`
+	DocType string `json:"doc_type"`
+	ID      string `json:"id"`
+	Hash    string `json:"hash"`
+}
+
+// LegacyDocumentPage is a page of ListLegacyDocuments; Next is empty after the last page
+type LegacyDocumentPage struct {
+	Documents []LegacyDocument `json:"documents"`
+	Scanned   int              `json:"scanned"`
+	Next      string           `json:"next"`
+}
+
+// DocumentMigration is the outcome of moving one raw key, as the chaincode and the journal record it
+type DocumentMigration struct {
+	Key     string `json:"key"`
+	DocType string `json:"doc_type,omitempty"`
+	ID      string `json:"id,omitempty"`
+	Hash    string `json:"hash,omitempty"`
+	// Status is migrated, conflict, missing or not_document; dry runs journal pending
+	Status string `json:"status"`
+	TxID   string `json:"tx_id,omitempty"`
+}
+
+// DocumentDigest reports where a document is stored and the hash of the copy reads return
+type DocumentDigest struct {
+	DocType    string `json:"doc_type"`
+	ID         string `json:"id"`
+	Namespaced bool   `json:"namespaced"`
+	Legacy     bool   `json:"legacy"`
+	Hash       string `json:"hash"`
+}
+
+// migrationState is the checkpoint sih-migrate saves after every batch
+type migrationState struct {
+	Next      string         `json:"next"`
+	Scanned   int            `json:"scanned"`
+	Counts    map[string]int `json:"counts"`
+	Done      bool           `json:"done"`
+	UpdatedAt string         `json:"updated_at"`
+}
+
+// keyMigrator moves the documents a v2 contract stored under raw IDs to their namespaced keys. The
+// ledger calls are fields so tests can run it against an in-memory ledger.
+type keyMigrator struct {
+	list    func(ctx context.Context, startKey string, pageSize int) (LegacyDocumentPage, error)
+	migrate func(ctx context.Context, keys []string) ([]DocumentMigration, error)
+	digest  func(ctx context.Context, docType, id string) (DocumentDigest, error)
+
+	batch  int
+	rate   float64
+	dryRun bool
+	// journal receives one JSON line per document moved, or that would be in a dry run
+	journal io.Writer
+	// save persists the state after every batch; dry runs do not save it
+	save func(*migrationState) error
+}
+
+// run migrates from state.Next until no legacy document is left or ctx is done. A batch is
+// journaled before the state moves past it, so the journal lists every document a resumed run
+// will not list again; a crash between the transaction and the journal is caught by verify,
+// which also looks for documents still under raw IDs.
+func (m *keyMigrator) run(ctx context.Context, state *migrationState) error {
+	if state.Counts == nil {
+		state.Counts = map[string]int{}
+	}
+	next := state.Next
+	for !state.Done {
+		started := time.Now()
+		page, err := m.list(ctx, next, m.batch)
+		if err != nil {
+			return fmt.Errorf("failed to list legacy documents after %q: %w", next, err)
+		}
+		migrations := make([]DocumentMigration, 0, len(page.Documents))
+		if m.dryRun {
+			for _, doc := range page.Documents {
+				migrations = append(migrations, DocumentMigration{Key: doc.Key, DocType: doc.DocType, ID: doc.ID, Hash: doc.Hash, Status: "pending"})
+			}
+		} else if len(page.Documents) > 0 {
+			keys := make([]string, len(page.Documents))
+			for i, doc := range page.Documents {
+				keys[i] = doc.Key
+			}
+			if migrations, err = m.migrate(ctx, keys); err != nil {
+				return fmt.Errorf("failed to migrate the batch from %q: %w", keys[0], err)
+			}
+		}
+		for _, migration := range migrations {
+			line, err := json.Marshal(migration)
+			if err != nil {
+				return err
+			}
+			if _, err := m.journal.Write(append(line, '\n')); err != nil {
+				return fmt.Errorf("failed to write the journal: %w", err)
+			}
+			state.Counts[migration.Status]++
+		}
+
+		next = page.Next
+		state.Scanned += page.Scanned
+		state.Done = page.Next == ""
+		state.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+		if !m.dryRun {
+			state.Next = page.Next
+			if err := m.save(state); err != nil {
+				return fmt.Errorf("failed to save the checkpoint: %w", err)
+			}
+		}
+		log.Printf("🚚 Scanned %d keys: %s", state.Scanned, formatMigrationCounts(state.Counts))
+		if err := m.throttle(ctx, len(page.Documents), time.Since(started)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// throttle holds a batch of n documents to the rate, in documents per second, so the migration
+// does not crowd out the checkpoints' traffic. A zero rate does not throttle.
+func (m *keyMigrator) throttle(ctx context.Context, n int, took time.Duration) error {
+	if m.rate <= 0 || n == 0 {
+		return ctx.Err()
+	}
+	wait := time.Duration(float64(n)/m.rate*float64(time.Second)) - took
+	if wait <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// migrationDiscrepancy is a journaled document verify found out of place, or one still stored
+// under its raw ID
+type migrationDiscrepancy struct {
+	Key     string `json:"key"`
+	DocType string `json:"doc_type"`
+	ID      string `json:"id"`
+	Problem string `json:"problem"`
+}
+
+// verify checks that every journaled document is under its namespaced key alone and unchanged,
+// then that no document is left under a raw ID
+func (m *keyMigrator) verify(ctx context.Context, journal io.Reader) (int, []migrationDiscrepancy, error) {
+	checked := 0
+	discrepancies := []migrationDiscrepancy{}
+	scanner := bufio.NewScanner(journal)
+	for scanner.Scan() {
+		var migration DocumentMigration
+		if err := json.Unmarshal(scanner.Bytes(), &migration); err != nil {
+			return checked, discrepancies, fmt.Errorf("invalid journal line %d: %w", checked+1, err)
+		}
+		if migration.Status != "migrated" {
+			continue
+		}
+		checked++
+		digest, err := m.digest(ctx, migration.DocType, migration.ID)
+		if err != nil {
+			return checked, discrepancies, fmt.Errorf("failed to read %s %s: %w", migration.DocType, migration.ID, err)
+		}
+		problem := ""
+		switch {
+		case !digest.Namespaced:
+			problem = "not under its namespaced key"
+		case digest.Legacy:
+			problem = "still under its raw ID as well"
+		case digest.Hash != migration.Hash:
+			problem = "changed since it was moved"
+		}
+		if problem != "" {
+			discrepancies = append(discrepancies, migrationDiscrepancy{Key: migration.Key, DocType: migration.DocType, ID: migration.ID, Problem: problem})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return checked, discrepancies, fmt.Errorf("failed to read the journal: %w", err)
+	}
+
+	next := ""
+	for {
+		page, err := m.list(ctx, next, maxMigrationBatch)
+		if err != nil {
+			return checked, discrepancies, fmt.Errorf("failed to list legacy documents after %q: %w", next, err)
+		}
+		for _, doc := range page.Documents {
+			discrepancies = append(discrepancies, migrationDiscrepancy{Key: doc.Key, DocType: doc.DocType, ID: doc.ID, Problem: "still under its raw ID"})
+		}
+		if page.Next == "" {
+			return checked, discrepancies, nil
+		}
+		next = page.Next
+	}
+}
+
+func formatMigrationCounts(counts map[string]int) string {
+	if len(counts) == 0 {
+		return "no legacy documents yet"
+	}
+	var parts []string
+	for _, status := range []string{"pending", "migrated", "conflict", "missing", "not_document"} {
+		if counts[status] > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", counts[status], status))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+func listLegacyDocuments(ctx context.Context, startKey string, pageSize int) (LegacyDocumentPage, error) {
+	result, err := evaluateTransaction(ctx, "ListLegacyDocuments", startKey, strconv.Itoa(pageSize))
+	if err != nil {
+		return LegacyDocumentPage{}, err
+	}
+	return decodeDocument[LegacyDocumentPage](result, "legacy document page")
+}
+
+func documentDigest(ctx context.Context, docType, id string) (DocumentDigest, error) {
+	result, err := evaluateTransaction(ctx, "GetDocumentDigest", docType, id)
+	if err != nil {
+		return DocumentDigest{}, err
+	}
+	return decodeDocument[DocumentDigest](result, "document digest")
+}
+
+func migrateLegacyDocuments(actor string) func(ctx context.Context, keys []string) ([]DocumentMigration, error) {
+	return func(ctx context.Context, keys []string) ([]DocumentMigration, error) {
+		keysJSON, err := json.Marshal(keys)
+		if err != nil {
+			return nil, err
+		}
+		result, err := submitTransaction(ctx, "MigrateLegacyDocuments", string(keysJSON), actor)
+		if err != nil {
+			return nil, err
+		}
+		migrations, err := decodeDocument[[]DocumentMigration](result.Payload, "key migration")
+		if err != nil {
+			return nil, err
+		}
+		for i := range migrations {
+			migrations[i].TxID = result.TxID
+		}
+		return migrations, nil
+	}
+}
+
+// RunKeyMigration is sih-migrate: it moves the documents of a v2 contract from raw IDs to the
+// namespaced keys of the current one, then verifies the move
+func RunKeyMigration() {
+	flags := flag.NewFlagSet("sih-migrate", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "list the documents that would move without moving them")
+	verifyOnly := flags.Bool("verify", false, "only verify a finished migration against its journal")
+	batch := flags.Int("batch", 50, fmt.Sprintf("documents per migration transaction, at most %d", maxMigrationBatch))
+	rate := flags.Float64("rate", 100, "documents per second at most; 0 does not throttle")
+	statePath := flags.String("state", "migration-state.json", "checkpoint file a stopped migration resumes from")
+	journalPath := flags.String("journal", "migration-journal.jsonl", "journal of the documents moved, which verification checks")
+	actor := flags.String("actor", getEnv("MIGRATION_ACTOR", "sih-migrate"), "actor recorded in the audit log")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: sih-migrate [flags]\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(os.Args[1:])
+	if *batch <= 0 || *batch > maxMigrationBatch {
+		log.Fatalf("-batch must be between 1 and %d", maxMigrationBatch)
+	}
+	if *dryRun && *verifyOnly {
+		log.Fatal("-dry-run and -verify cannot be combined")
+	}
+
+	initFabricConnection()
+	defer closeFabricConnection()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx = withTarget(ctx, defaultTarget)
+	m := &keyMigrator{
+		list:    listLegacyDocuments,
+		migrate: migrateLegacyDocuments(*actor),
+		digest:  documentDigest,
+		batch:   *batch,
+		rate:    *rate,
+		dryRun:  *dryRun,
+		save:    func(state *migrationState) error { return writeJSONFile(*statePath, state) },
+	}
+
+	if !*verifyOnly {
+		state := &migrationState{}
+		if !*dryRun {
+			if err := readJSONFile(*statePath, state); err != nil && !errors.Is(err, os.ErrNotExist) {
+				log.Fatalf("Failed to read the checkpoint: %v", err)
+			}
+			if state.Next != "" || state.Done {
+				log.Printf("🚚 Resuming after %d scanned keys", state.Scanned)
+			}
+		}
+		journalFile := *journalPath
+		if *dryRun {
+			journalFile += ".dry-run"
+		}
+		journal, err := os.OpenFile(journalFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			log.Fatal(err)
+		}
+		m.journal = journal
+		err = m.run(ctx, state)
+		if closeErr := journal.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			stop()
+			log.Fatalf("❌ Migration stopped after %d scanned keys: %v", state.Scanned, err)
+		}
+		if *dryRun {
+			log.Printf("🚚 Dry run finished: %s; listed in %s", formatMigrationCounts(state.Counts), journalFile)
+			return
+		}
+		log.Printf("🚚 Migration finished: %s", formatMigrationCounts(state.Counts))
+	}
+
+	journal, err := os.Open(*journalPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer journal.Close()
+	checked, discrepancies, err := m.verify(ctx, journal)
+	if err != nil {
+		stop()
+		log.Fatalf("❌ Verification stopped after %d documents: %v", checked, err)
+	}
+	for _, d := range discrepancies {
+		log.Printf("❌ %s %s (key %q): %s", d.DocType, d.ID, d.Key, d.Problem)
+	}
+	log.Printf("🔎 Verified %d moved documents, %d discrepancies", checked, len(discrepancies))
+	if len(discrepancies) > 0 {
+		stop()
+		journal.Close()
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,119 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// memoryKeyLedger keeps raw and namespaced documents the way the chaincode does
+type memoryKeyLedger struct {
+	legacy     map[string]LegacyDocument
+	namespaced map[string]string
+	failAfter  int
+}
+
+func (l *memoryKeyLedger) list(_ context.Context, startKey string, pageSize int) (LegacyDocumentPage, error) {
+	keys := []string{}
+	for key := range l.legacy {
+		if key >= startKey {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	page := LegacyDocumentPage{Documents: []LegacyDocument{}}
+	for _, key := range keys {
+		if len(page.Documents) == pageSize {
+			page.Next = key
+			break
+		}
+		page.Scanned++
+		page.Documents = append(page.Documents, l.legacy[key])
+	}
+	return page, nil
+}
+
+func (l *memoryKeyLedger) migrate(_ context.Context, keys []string) ([]DocumentMigration, error) {
+	if l.failAfter == 0 {
+		return nil, errors.New("endorsement failed")
+	}
+	l.failAfter--
+	var migrations []DocumentMigration
+	for _, key := range keys {
+		doc := l.legacy[key]
+		migration := DocumentMigration{Key: key, DocType: doc.DocType, ID: doc.ID, Hash: doc.Hash, Status: "migrated", TxID: "tx_" + key}
+		if _, taken := l.namespaced[doc.DocType+"/"+doc.ID]; taken {
+			migration.Status = "conflict"
+		} else {
+			l.namespaced[doc.DocType+"/"+doc.ID] = doc.Hash
+			delete(l.legacy, key)
+		}
+		migrations = append(migrations, migration)
+	}
+	return migrations, nil
+}
+
+func (l *memoryKeyLedger) digest(_ context.Context, docType, id string) (DocumentDigest, error) {
+	hash, namespaced := l.namespaced[docType+"/"+id]
+	_, legacy := l.legacy[id]
+	return DocumentDigest{DocType: docType, ID: id, Namespaced: namespaced, Legacy: legacy, Hash: hash}, nil
+}
+
+func TestKeyMigration(t *testing.T) {
+	l := &memoryKeyLedger{legacy: map[string]LegacyDocument{}, namespaced: map[string]string{"incident/inc_3": "h_new"}, failAfter: 1}
+	for _, doc := range []LegacyDocument{
+		{Key: "did_1", DocType: "did", ID: "did_1", Hash: "h1"},
+		{Key: "did_2", DocType: "did", ID: "did_2", Hash: "h2"},
+		{Key: "inc_3", DocType: "incident", ID: "inc_3", Hash: "h3"},
+		{Key: "sos_4", DocType: "sos", ID: "sos_4", Hash: "h4"},
+	} {
+		l.legacy[doc.Key] = doc
+	}
+	var journal bytes.Buffer
+	var saved []migrationState
+	m := &keyMigrator{list: l.list, migrate: l.migrate, digest: l.digest, batch: 2, journal: &journal,
+		save: func(state *migrationState) error { saved = append(saved, *state); return nil }}
+
+	// A dry run lists everything and moves nothing
+	m.dryRun = true
+	var plan bytes.Buffer
+	m.journal = &plan
+	dry := &migrationState{}
+	if err := m.run(context.Background(), dry); err != nil {
+		t.Fatal(err)
+	}
+	if dry.Counts["pending"] != 4 || len(l.legacy) != 4 || len(saved) != 0 || strings.Count(plan.String(), "\n") != 4 {
+		t.Fatalf("expected four pending documents and nothing saved, got %+v", dry)
+	}
+
+	// The second batch fails; the checkpoint lets the next run carry on after the first
+	m.dryRun, m.journal = false, &journal
+	state := &migrationState{}
+	if err := m.run(context.Background(), state); err == nil {
+		t.Fatal("expected the failed batch to stop the migration")
+	}
+	if len(saved) != 1 || saved[0].Next != "inc_3" || saved[0].Counts["migrated"] != 2 || saved[0].Done {
+		t.Fatalf("expected a checkpoint after the first batch, got %+v", saved)
+	}
+	l.failAfter = 1
+	resumed := saved[0]
+	if err := m.run(context.Background(), &resumed); err != nil {
+		t.Fatal(err)
+	}
+	if !resumed.Done || resumed.Counts["migrated"] != 3 || resumed.Counts["conflict"] != 1 || resumed.Scanned != 4 {
+		t.Fatalf("expected the migration finished with one conflict, got %+v", resumed)
+	}
+
+	// Verification catches the conflict left under its raw ID and a document changed since
+	l.namespaced["did/did_2"] = "h_changed"
+	checked, discrepancies, err := m.verify(context.Background(), strings.NewReader(journal.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checked != 3 || len(discrepancies) != 2 || discrepancies[0].ID != "did_2" || discrepancies[1].Problem != "still under its raw ID" {
+		t.Errorf("expected the changed DID and the conflicting incident reported, got %d checked, %+v", checked, discrepancies)
+	}
+}
@@ -0,0 +1,268 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"time"
+
+	gcpkms "cloud.google.com/go/kms/apiv1"
+	"cloud.google.com/go/kms/apiv1/kmspb"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"github.com/hyperledger/fabric-gateway/pkg/identity"
+	"google.golang.org/api/option"
+)
+
+// kmsClient signs SHA-256 digests with an asymmetric key that never leaves a cloud KMS
+type kmsClient interface {
+	signDigest(ctx context.Context, digest []byte) ([]byte, error)
+	// publicKey returns the key's DER-encoded SubjectPublicKeyInfo
+	publicKey(ctx context.Context) ([]byte, error)
+}
+
+// kmsSigner turns KMS signatures into the low-S ECDSA signatures Fabric accepts, checking each one
+// against the identity certificate so a misconfigured key fails loudly rather than at endorsement.
+// It fails closed: when the KMS cannot sign, nothing is signed.
+type kmsSigner struct {
+	client    kmsClient
+	publicKey *ecdsa.PublicKey
+	timeout   time.Duration
+}
+
+// newGatewayKMSSign signs as the gateway identity with its key in AWS KMS or GCP Cloud KMS
+func newGatewayKMSSign(kind string) identity.Sign {
+	certificate := gatewayCertificate()
+	publicKey, ok := certificate.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		panic(fmt.Errorf("KMS signing requires an ECDSA certificate, got %T", certificate.PublicKey))
+	}
+
+	// A file key standing in for the KMS would defeat the key custody the KMS is there for
+	if getEnv("KMS_FALLBACK", "") != "" {
+		panic(errors.New("KMS_FALLBACK is not supported; KMS signing fails closed"))
+	}
+	client, err := newKMSClient(kind, "KMS_KEY_ID")
+	if err != nil {
+		panic(fmt.Errorf("failed to configure %s signing: %w", kind, err))
+	}
+	signer := &kmsSigner{client: client, publicKey: publicKey, timeout: getEnvDuration("KMS_TIMEOUT", 5*time.Second)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), signer.timeout)
+	defer cancel()
+	if err := signer.checkPublicKey(ctx, getEnv("KMS_PUBLIC_KEY_CACHE", "")); err != nil {
+		panic(fmt.Errorf("%s key does not belong to the gateway identity: %w", kind, err))
+	}
+
+	log.Printf("🔑 Signing as the gateway identity with %s", kind)
+	return signer.sign
+}
+
+// cloudKMS is a key in AWS KMS or GCP Cloud KMS. Asymmetric keys sign for the gateway identity;
+// symmetric keys wrap evidence data keys.
+type cloudKMS interface {
+	kmsClient
+	masterKey
+}
+
+// newKMSClient configures a client for the key named by the keyVar environment variable. Each SDK
+// finds its credentials the usual way: for AWS the environment, shared configuration, web identity
+// or instance role, and for GCP application default credentials. Both retry throttling and outages
+// within KMS_TIMEOUT.
+func newKMSClient(kind, keyVar string) (cloudKMS, error) {
+	keyID := getEnv(keyVar, "")
+	if keyID == "" {
+		return nil, fmt.Errorf("%s is not set", keyVar)
+	}
+	endpoint, timeout := getEnv("KMS_ENDPOINT", ""), getEnvDuration("KMS_TIMEOUT", 5*time.Second)
+	ctx := context.Background()
+
+	if kind == "gcp-kms" {
+		var opts []option.ClientOption
+		if endpoint != "" {
+			opts = append(opts, option.WithEndpoint(endpoint))
+		}
+		client, err := gcpkms.NewKeyManagementClient(ctx, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return &gcpKMS{client: client, keyName: keyID, timeout: timeout}, nil
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx, config.WithRetryMaxAttempts(getEnvInt("KMS_RETRIES", 2)+1))
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Region == "" {
+		return nil, errors.New("AWS_REGION must be set")
+	}
+	client := newAWSKMS(cfg, endpoint, keyID)
+	client.timeout = timeout
+	return client, nil
+}
+
+// checkPublicKey compares the KMS key with the certificate. The key is read from cachePath when it
+// has been fetched before, so a restart does not depend on the KMS being reachable. If it cannot be
+// fetched at all startup continues, since every signature is verified against the certificate anyway.
+func (s *kmsSigner) checkPublicKey(ctx context.Context, cachePath string) error {
+	der, err := readCachedPublicKey(cachePath)
+	if err != nil {
+		der, err = s.client.publicKey(ctx)
+		if err != nil {
+			log.Printf("⚠️ Could not fetch the KMS public key, relying on per-signature checks: %v", err)
+			return nil
+		}
+		if cachePath != "" {
+			block := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+			if err := os.WriteFile(cachePath, block, 0o644); err != nil {
+				log.Printf("Failed to cache KMS public key in %s: %v", cachePath, err)
+			}
+		}
+	}
+
+	parsed, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return err
+	}
+	if !s.publicKey.Equal(parsed) {
+		return errors.New("public keys differ")
+	}
+	return nil
+}
+
+func readCachedPublicKey(path string) ([]byte, error) {
+	if path == "" {
+		return nil, os.ErrNotExist
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, fmt.Errorf("%s does not hold a PEM public key", path)
+	}
+	return block.Bytes, nil
+}
+
+// sign implements identity.Sign
+func (s *kmsSigner) sign(digest []byte) ([]byte, error) {
+	signature, err := s.signOnce(digest)
+	if err != nil {
+		return nil, fmt.Errorf("KMS signing failed: %w", err)
+	}
+	return signature, nil
+}
+
+func (s *kmsSigner) signOnce(digest []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	der, err := s.client.signDigest(ctx, digest)
+	if err != nil {
+		return nil, err
+	}
+	if !ecdsa.VerifyASN1(s.publicKey, digest, der) {
+		return nil, errors.New("signature does not verify against the gateway certificate")
+	}
+	return lowSSignature(s.publicKey, der)
+}
+
+// lowSSignature rewrites an ECDSA signature with s in the lower half of the curve order. KMS
+// services return either form, but Fabric rejects high-S signatures as malleable.
+func lowSSignature(publicKey *ecdsa.PublicKey, der []byte) ([]byte, error) {
+	var sig struct{ R, S *big.Int }
+	if _, err := asn1.Unmarshal(der, &sig); err != nil {
+		return nil, err
+	}
+	order := publicKey.Curve.Params().N
+	if sig.S.Cmp(new(big.Int).Rsh(order, 1)) > 0 {
+		sig.S.Sub(order, sig.S)
+	}
+	return asn1.Marshal(sig)
+}
+
+// awsKMS calls AWS KMS through the AWS SDK. Timeout bounds the calls that wrap evidence keys; the
+// signer bounds its own.
+type awsKMS struct {
+	client  *kms.Client
+	keyID   string
+	timeout time.Duration
+}
+
+// newAWSKMS uses endpoint, when set, in place of the region's KMS endpoint
+func newAWSKMS(cfg aws.Config, endpoint, keyID string) *awsKMS {
+	client := kms.NewFromConfig(cfg, func(o *kms.Options) {
+		if endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+		}
+	})
+	return &awsKMS{client: client, keyID: keyID}
+}
+
+func (k *awsKMS) signDigest(ctx context.Context, digest []byte) ([]byte, error) {
+	out, err := k.client.Sign(ctx, &kms.SignInput{
+		KeyId:            aws.String(k.keyID),
+		Message:          digest,
+		MessageType:      types.MessageTypeDigest,
+		SigningAlgorithm: types.SigningAlgorithmSpecEcdsaSha256,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Signature, nil
+}
+
+func (k *awsKMS) publicKey(ctx context.Context) ([]byte, error) {
+	out, err := k.client.GetPublicKey(ctx, &kms.GetPublicKeyInput{KeyId: aws.String(k.keyID)})
+	if err != nil {
+		return nil, err
+	}
+	return out.PublicKey, nil
+}
+
+// gcpKMS calls Cloud KMS through its client library. Signing keys are named by crypto key version,
+// projects/*/locations/*/keyRings/*/cryptoKeys/*/cryptoKeyVersions/*.
+type gcpKMS struct {
+	client  *gcpkms.KeyManagementClient
+	keyName string
+	timeout time.Duration
+}
+
+func (k *gcpKMS) signDigest(ctx context.Context, digest []byte) ([]byte, error) {
+	resp, err := k.client.AsymmetricSign(ctx, &kmspb.AsymmetricSignRequest{
+		Name:   k.keyName,
+		Digest: &kmspb.Digest{Digest: &kmspb.Digest_Sha256{Sha256: digest}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Signature, nil
+}
+
+func (k *gcpKMS) publicKey(ctx context.Context) ([]byte, error) {
+	resp, err := k.client.GetPublicKey(ctx, &kmspb.GetPublicKeyRequest{Name: k.keyName})
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode([]byte(resp.Pem))
+	if block == nil {
+		return nil, errors.New("Cloud KMS returned no PEM public key")
+	}
+	return block.Bytes, nil
+}
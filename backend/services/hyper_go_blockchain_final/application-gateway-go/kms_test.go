@@ -0,0 +1,221 @@
+package gateway
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	gcpkms "cloud.google.com/go/kms/apiv1"
+	"cloud.google.com/go/kms/apiv1/kmspb"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// highSSignature signs digest and returns the signature with s in the upper half of the order
+func highSSignature(t *testing.T, key *ecdsa.PrivateKey, digest []byte) []byte {
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	order := key.Curve.Params().N
+	if s.Cmp(new(big.Int).Rsh(order, 1)) <= 0 {
+		s.Sub(order, s)
+	}
+	der, err := asn1.Marshal(struct{ R, S *big.Int }{r, s})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+// testAWSConfig points the AWS SDK at a test server with static credentials
+func testAWSConfig(server *httptest.Server, attempts int) aws.Config {
+	return aws.Config{
+		Region:           "ap-south-1",
+		Credentials:      credentials.NewStaticCredentialsProvider("AKID", "secret", ""),
+		HTTPClient:       server.Client(),
+		RetryMaxAttempts: attempts,
+	}
+}
+
+// fakeCloudKMS serves the Cloud KMS calls the gateway makes
+type fakeCloudKMS struct {
+	kmspb.UnimplementedKeyManagementServiceServer
+	key   *ecdsa.PrivateKey
+	spki  []byte
+	calls int
+}
+
+func (f *fakeCloudKMS) GetPublicKey(_ context.Context, req *kmspb.GetPublicKeyRequest) (*kmspb.PublicKey, error) {
+	return &kmspb.PublicKey{Name: req.Name, Pem: string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: f.spki}))}, nil
+}
+
+func (f *fakeCloudKMS) AsymmetricSign(_ context.Context, req *kmspb.AsymmetricSignRequest) (*kmspb.AsymmetricSignResponse, error) {
+	f.calls++
+	if f.key == nil {
+		return nil, status.Error(codes.PermissionDenied, "key disabled")
+	}
+	signature, err := ecdsa.SignASN1(rand.Reader, f.key, req.Digest.GetSha256())
+	return &kmspb.AsymmetricSignResponse{Name: req.Name, Signature: signature}, err
+}
+
+// startFakeCloudKMS serves fake over gRPC and returns a client for one of its keys
+func startFakeCloudKMS(t *testing.T, fake *fakeCloudKMS) *gcpKMS {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	kmspb.RegisterKeyManagementServiceServer(server, fake)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	client, err := gcpkms.NewKeyManagementClient(context.Background(), option.WithEndpoint(listener.Addr().String()),
+		option.WithoutAuthentication(), option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return &gcpKMS{client: client, keyName: "projects/p/locations/asia-south1/keyRings/sih/cryptoKeys/gateway/cryptoKeyVersions/1"}
+}
+
+func TestAWSKMSSigner(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spki, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") ||
+			!strings.Contains(r.Header.Get("Authorization"), "/ap-south-1/kms/aws4_request") {
+			t.Errorf("request not signed for kms: %q", r.Header.Get("Authorization"))
+		}
+		var in struct {
+			KeyId       string
+			Message     []byte
+			MessageType string
+		}
+		json.NewDecoder(r.Body).Decode(&in)
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		switch r.Header.Get("X-Amz-Target") {
+		case "TrentService.GetPublicKey":
+			json.NewEncoder(w).Encode(map[string][]byte{"PublicKey": spki})
+		case "TrentService.Sign":
+			if in.KeyId != "alias/sih-gateway" || in.MessageType != "DIGEST" {
+				t.Errorf("unexpected sign request %+v", in)
+			}
+			json.NewEncoder(w).Encode(map[string][]byte{"Signature": highSSignature(t, key, in.Message)})
+		}
+	}))
+	defer server.Close()
+
+	signer := &kmsSigner{
+		client:    newAWSKMS(testAWSConfig(server, 1), server.URL, "alias/sih-gateway"),
+		publicKey: &key.PublicKey,
+		timeout:   time.Second,
+	}
+
+	cache := t.TempDir() + "/gateway.pub"
+	if err := signer.checkPublicKey(t.Context(), cache); err != nil {
+		t.Fatal(err)
+	}
+	if cached, err := readCachedPublicKey(cache); err != nil || string(cached) != string(spki) {
+		t.Errorf("public key was not cached: %v", err)
+	}
+
+	digest := sha256.Sum256([]byte("tourist registration"))
+	signature, err := signer.sign(digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sig struct{ R, S *big.Int }
+	if _, err := asn1.Unmarshal(signature, &sig); err != nil {
+		t.Fatal(err)
+	}
+	if sig.S.Cmp(new(big.Int).Rsh(key.Curve.Params().N, 1)) > 0 {
+		t.Error("expected a low-S signature")
+	}
+	if !ecdsa.VerifyASN1(&key.PublicKey, digest[:], signature) {
+		t.Error("normalized signature does not verify")
+	}
+}
+
+func TestKMSSignerFailsClosed(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"__type": "KMSInternalException", "message": "backend unavailable"}`))
+	}))
+	defer server.Close()
+	signer := &kmsSigner{client: newAWSKMS(testAWSConfig(server, 2), server.URL, "alias/sih-gateway"), publicKey: &key.PublicKey, timeout: 5 * time.Second}
+
+	digest := sha256.Sum256([]byte("sos"))
+	if _, err := signer.sign(digest[:]); err == nil {
+		t.Error("expected nothing signed while the KMS is down")
+	}
+	if calls != 2 {
+		t.Errorf("expected the SDK to retry an outage once, got %d calls", calls)
+	}
+
+	// A disabled key is not retried and nothing else signs in its place
+	fake := &fakeCloudKMS{}
+	signer = &kmsSigner{client: startFakeCloudKMS(t, fake), publicKey: &key.PublicKey, timeout: time.Second}
+	if _, err := signer.sign(digest[:]); err == nil || status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expected the denial returned, got %v", err)
+	}
+	if fake.calls != 1 {
+		t.Errorf("expected a denied signature not retried, got %d calls", fake.calls)
+	}
+}
+
+func TestGCPKMSSigner(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	spki, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	signer := &kmsSigner{client: startFakeCloudKMS(t, &fakeCloudKMS{key: key, spki: spki}), publicKey: &key.PublicKey, timeout: time.Second}
+	if err := signer.checkPublicKey(t.Context(), ""); err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256([]byte("incident"))
+	signature, err := signer.sign(digest[:])
+	if err != nil || !ecdsa.VerifyASN1(&key.PublicKey, digest[:], signature) {
+		t.Errorf("expected a signature verifying against the certificate, got %v", err)
+	}
+}
+
+func TestKMSPublicKeyMismatch(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	spki, _ := x509.MarshalPKIXPublicKey(&other.PublicKey)
+
+	signer := &kmsSigner{client: startFakeCloudKMS(t, &fakeCloudKMS{spki: spki}), publicKey: &key.PublicKey}
+	if err := signer.checkPublicKey(t.Context(), ""); err == nil {
+		t.Error("expected a KMS key that does not match the certificate to be rejected")
+	}
+}
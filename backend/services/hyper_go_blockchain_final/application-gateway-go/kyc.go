@@ -0,0 +1,917 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	kycPrefix          = "kyc/"
+	kycReviewKey       = "sih:kyc:review"
+	kycApplicationPart = "application"
+	maxKYCReviewList   = 200
+
+	errCodeKYCDisabled  = "KYC_DISABLED"
+	errCodeKYCDuplicate = "KYC_DUPLICATE"
+	errCodeKYCState     = "KYC_INVALID_STATE"
+)
+
+// KYC application statuses
+const (
+	kycReview   = "review"
+	kycVerified = "verified"
+	kycIssued   = "issued"
+	kycRejected = "rejected"
+)
+
+var (
+	kycDocumentTypes = []string{"aadhaar", "passport"}
+	// kycArtifactNames are the multipart file parts kept as verification artifacts
+	kycArtifactNames = []string{"document_front", "document_back", "selfie"}
+	kycArtifactTypes = []string{"image/jpeg", "image/png", "application/pdf"}
+
+	aadhaarRegex     = regexp.MustCompile(`^[2-9][0-9]{11}$`)
+	passportRegex    = regexp.MustCompile(`^[A-Z0-9]{6,9}$`)
+	indiaPassport    = regexp.MustCompile(`^[A-Z][0-9]{7}$`)
+	countryCodeRegex = regexp.MustCompile(`^[A-Z]{2}$`)
+
+	errKYCDuplicate = errors.New("the identity already has an issued or pending application")
+	errKYCState     = errors.New("the application is not awaiting review")
+)
+
+// KYCConsent is what the tourist agreed to; its salted hash becomes the DID's consent hash
+type KYCConsent struct {
+	Purposes      []string `json:"purposes" binding:"required"`
+	PolicyVersion string   `json:"policyVersion" binding:"required"`
+}
+
+// KYCApplicationRequest onboards a tourist from a passport or Aadhaar number. The number itself is
+// never stored: only its keyed hash and last four characters are.
+type KYCApplicationRequest struct {
+	DocumentType   string     `json:"documentType" binding:"required"`
+	DocumentNumber string     `json:"documentNumber" binding:"required"`
+	Nationality    string     `json:"nationality"`
+	FullName       string     `json:"fullName" binding:"required"`
+	DateOfBirth    string     `json:"dateOfBirth" binding:"required"`
+	ExpiresAt      string     `json:"expiresAt" binding:"required"`
+	Consent        KYCConsent `json:"consent" binding:"required"`
+}
+
+// normalize strips the separators people type into document numbers and defaults the nationality
+// of Aadhaar holders
+func (r *KYCApplicationRequest) normalize() {
+	r.DocumentNumber = strings.ToUpper(strings.NewReplacer(" ", "", "-", "").Replace(r.DocumentNumber))
+	r.Nationality = strings.ToUpper(strings.TrimSpace(r.Nationality))
+	if r.DocumentType == "aadhaar" && r.Nationality == "" {
+		r.Nationality = "IN"
+	}
+}
+
+func (r KYCApplicationRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.oneOf("documentType", r.DocumentType, kycDocumentTypes)
+	switch r.DocumentType {
+	case "aadhaar":
+		if !aadhaarRegex.MatchString(r.DocumentNumber) || !verhoeffValid(r.DocumentNumber) {
+			v.add("documentNumber", "must be a valid 12 digit Aadhaar number")
+		}
+		if r.Nationality != "IN" {
+			v.add("nationality", "must be IN for Aadhaar")
+		}
+	case "passport":
+		if !passportRegex.MatchString(r.DocumentNumber) || (r.Nationality == "IN" && !indiaPassport.MatchString(r.DocumentNumber)) {
+			v.add("documentNumber", "must be a valid passport number")
+		}
+		if !countryCodeRegex.MatchString(r.Nationality) {
+			v.add("nationality", "must be an ISO 3166 alpha-2 country code")
+		}
+	}
+	if name := strings.TrimSpace(r.FullName); name == "" || len(name) > 128 {
+		v.add("fullName", "must be 1-128 characters")
+	}
+	if dob, err := time.Parse(time.DateOnly, r.DateOfBirth); err != nil || !dob.Before(time.Now()) {
+		v.add("dateOfBirth", "must be a past date in YYYY-MM-DD form")
+	}
+	v.future("expiresAt", r.ExpiresAt)
+	if len(r.Consent.Purposes) == 0 {
+		v.add("consent.purposes", "must name at least one purpose")
+	}
+	for _, purpose := range r.Consent.Purposes {
+		v.identifier("consent.purposes", purpose)
+	}
+	v.identifier("consent.policyVersion", r.Consent.PolicyVersion)
+	return v.errors
+}
+
+// KYCReviewRequest approves or rejects an application in the review queue
+type KYCReviewRequest struct {
+	Reviewer string `json:"reviewer" binding:"required"`
+	Note     string `json:"note"`
+}
+
+func (r KYCReviewRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.identifier("reviewer", r.Reviewer)
+	if len(r.Note) > 1000 {
+		v.add("note", "must not exceed 1000 characters")
+	}
+	return v.errors
+}
+
+// KYCArtifact is a verification artifact kept in the evidence store
+type KYCArtifact struct {
+	Name      string `json:"name"`
+	MediaType string `json:"media_type"`
+	Size      int    `json:"size"`
+	SHA256    string `json:"sha256"`
+}
+
+// KYCVerification is the verifier's verdict; Verifier is none when no verifier is configured
+type KYCVerification struct {
+	Verifier  string `json:"verifier"`
+	Verified  bool   `json:"verified"`
+	Reason    string `json:"reason,omitempty"`
+	CheckedAt string `json:"checked_at"`
+}
+
+// KYCApplication is an onboarding record, kept off-chain next to its artifacts
+type KYCApplication struct {
+	ApplicationID    string          `json:"application_id"`
+	Status           string          `json:"status"`
+	DocumentType     string          `json:"document_type"`
+	Nationality      string          `json:"nationality"`
+	IdentifierHash   string          `json:"identifier_hash"`
+	MaskedIdentifier string          `json:"masked_identifier"`
+	FullName         string          `json:"full_name"`
+	DateOfBirth      string          `json:"date_of_birth"`
+	DigitalID        string          `json:"digital_id"`
+	ExpiresAt        string          `json:"expires_at"`
+	Consent          KYCConsent      `json:"consent"`
+	ConsentSalt      string          `json:"consent_salt"`
+	ConsentHash      string          `json:"consent_hash"`
+	Artifacts        []KYCArtifact   `json:"artifacts"`
+	Verification     KYCVerification `json:"verification"`
+	ReviewedBy       string          `json:"reviewed_by,omitempty"`
+	ReviewNote       string          `json:"review_note,omitempty"`
+	ReviewedAt       string          `json:"reviewed_at,omitempty"`
+	TxID             string          `json:"tx_id,omitempty"`
+	// ArtifactsPurgedAt is set when the tourist withdrew consent to data retention
+	ArtifactsPurgedAt string `json:"artifacts_purged_at,omitempty"`
+	// ErasedAt is set when the tourist's personal data was erased; the name, date of birth and
+	// masked identifier are then blank
+	ErasedAt    string `json:"erased_at,omitempty"`
+	SubmittedAt string `json:"submitted_at"`
+	UpdatedAt   string `json:"updated_at"`
+}
+
+// kycUpload is an artifact read from the request, before it is stored
+type kycUpload struct {
+	name      string
+	mediaType string
+	data      []byte
+}
+
+// kycVerifier checks a document number with the issuing authority or a KYC provider
+type kycVerifier interface {
+	name() string
+	verify(ctx context.Context, app *KYCApplication, documentNumber string) (verified bool, reason string, err error)
+}
+
+// httpKYCVerifier posts the application to a verification service that answers
+// {"verified": bool, "reason": "..."}
+type httpKYCVerifier struct {
+	endpoint   string
+	token      string
+	httpClient *http.Client
+}
+
+func (v *httpKYCVerifier) name() string { return "http" }
+
+func (v *httpKYCVerifier) verify(ctx context.Context, app *KYCApplication, documentNumber string) (bool, string, error) {
+	body, err := json.Marshal(map[string]string{
+		"application_id":  app.ApplicationID,
+		"document_type":   app.DocumentType,
+		"document_number": documentNumber,
+		"nationality":     app.Nationality,
+		"full_name":       app.FullName,
+		"date_of_birth":   app.DateOfBirth,
+	})
+	if err != nil {
+		return false, "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, v.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return false, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if v.token != "" {
+		req.Header.Set("Authorization", "Bearer "+v.token)
+	}
+	resp, err := v.httpClient.Do(req)
+	if err != nil {
+		return false, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return false, "", fmt.Errorf("verifier responded with %d: %s", resp.StatusCode, bytes.TrimSpace(body))
+	}
+	var verdict struct {
+		Verified bool   `json:"verified"`
+		Reason   string `json:"reason"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&verdict); err != nil {
+		return false, "", fmt.Errorf("invalid verifier response: %w", err)
+	}
+	return verdict.Verified, verdict.Reason, nil
+}
+
+// reviewQueue holds the applications awaiting manual review, oldest first. Enrollment requests
+// use the same queue under their own key.
+type reviewQueue interface {
+	add(ctx context.Context, applicationID string, at time.Time) error
+	remove(ctx context.Context, applicationID string) error
+	list(ctx context.Context, limit int) ([]string, error)
+}
+
+// kycService verifies onboarding applications and issues DIDs for them
+type kycService struct {
+	pepper      []byte
+	issuer      string
+	didMethod   string
+	verifier    kycVerifier
+	queue       reviewQueue
+	maxArtifact int64
+	// mu serializes submissions, so one identity cannot open two applications at once
+	mu sync.Mutex
+}
+
+var onboarding *kycService
+
+// initKYC runs after initEvidenceStore and initDocumentCache
+func initKYC() {
+	if !getEnvBool("KYC_ENABLED", false) {
+		log.Println("🪪 KYC_ENABLED not set, KYC onboarding disabled")
+		return
+	}
+	pepper := getEnv("KYC_PEPPER", "")
+	if len(pepper) < 16 {
+		panic(fmt.Errorf("KYC_PEPPER must be set to at least 16 characters when KYC_ENABLED is set"))
+	}
+	var queue reviewQueue = newMemoryReviewQueue()
+	backend := "memory"
+	if documentCache != nil {
+		queue, backend = redisReviewQueue{documentCache, kycReviewKey}, "Redis"
+	}
+	onboarding = &kycService{
+		pepper:      []byte(pepper),
+		issuer:      getEnv("KYC_ISSUER", "kyc-onboarding"),
+		didMethod:   getEnv("KYC_DID_METHOD", "sih"),
+		queue:       queue,
+		maxArtifact: int64(getEnvInt("KYC_MAX_ARTIFACT_BYTES", 5<<20)),
+	}
+	if errs := validateDocumentID("issuer", onboarding.issuer); len(errs) > 0 {
+		panic(fmt.Errorf("KYC_ISSUER %q is not an identifier", onboarding.issuer))
+	}
+	if endpoint := getEnv("KYC_VERIFIER_URL", ""); endpoint != "" {
+		onboarding.verifier = &httpKYCVerifier{
+			endpoint:   endpoint,
+			token:      getEnv("KYC_VERIFIER_TOKEN", ""),
+			httpClient: &http.Client{Timeout: getEnvDuration("KYC_VERIFIER_TIMEOUT", 10*time.Second)},
+		}
+	}
+	verifier := "every application reviewed manually"
+	if onboarding.verifier != nil {
+		verifier = "verifying with " + getEnv("KYC_VERIFIER_URL", "")
+	}
+	log.Printf("🪪 KYC onboarding enabled, %s, review queue in %s", verifier, backend)
+}
+
+// keyedHash is an HMAC under the pepper, so identifiers cannot be recovered by hashing every
+// possible Aadhaar number
+func (k *kycService) keyedHash(parts ...string) string {
+	mac := hmac.New(sha256.New, k.pepper)
+	mac.Write([]byte(strings.Join(parts, "|")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// identifierHash identifies a document across applications
+func (k *kycService) identifierHash(req KYCApplicationRequest) string {
+	return k.keyedHash("identity", req.DocumentType, req.Nationality, req.DocumentNumber)
+}
+
+// digitalID is derived from the identifier hash, so an identity always onboards to the same DID
+func (k *kycService) digitalID(identifierHash string) string {
+	return "did:" + k.didMethod + ":" + k.keyedHash("did", identifierHash)[:32]
+}
+
+// kycConsentHash is the SHA-256 of the salt followed by the canonical consent record
+func kycConsentHash(salt, digitalID string, consent KYCConsent) string {
+	purposes := slices.Clone(consent.Purposes)
+	slices.Sort(purposes)
+	record, _ := json.Marshal(struct {
+		DigitalID     string   `json:"digital_id"`
+		Purposes      []string `json:"purposes"`
+		PolicyVersion string   `json:"policy_version"`
+	}{digitalID, slices.Compact(purposes), consent.PolicyVersion})
+	sum := sha256.Sum256(append([]byte(salt), record...))
+	return hex.EncodeToString(sum[:])
+}
+
+// maskIdentifier keeps the last four characters
+func maskIdentifier(number string) string {
+	if len(number) <= 4 {
+		return strings.Repeat("X", len(number))
+	}
+	return strings.Repeat("X", len(number)-4) + number[len(number)-4:]
+}
+
+var (
+	verhoeffMultiply = [10][10]int{
+		{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, {1, 2, 3, 4, 0, 6, 7, 8, 9, 5}, {2, 3, 4, 0, 1, 7, 8, 9, 5, 6},
+		{3, 4, 0, 1, 2, 8, 9, 5, 6, 7}, {4, 0, 1, 2, 3, 9, 5, 6, 7, 8}, {5, 9, 8, 7, 6, 0, 4, 3, 2, 1},
+		{6, 5, 9, 8, 7, 1, 0, 4, 3, 2}, {7, 6, 5, 9, 8, 2, 1, 0, 4, 3}, {8, 7, 6, 5, 9, 3, 2, 1, 0, 4},
+		{9, 8, 7, 6, 5, 4, 3, 2, 1, 0},
+	}
+	verhoeffPermute = [8][10]int{
+		{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, {1, 5, 7, 6, 2, 8, 3, 0, 9, 4}, {5, 8, 0, 3, 7, 9, 6, 1, 4, 2},
+		{8, 9, 1, 6, 0, 4, 3, 5, 2, 7}, {9, 4, 5, 3, 1, 2, 6, 8, 7, 0}, {4, 2, 8, 6, 5, 7, 3, 9, 0, 1},
+		{2, 7, 9, 3, 8, 0, 6, 4, 1, 5}, {7, 0, 4, 6, 9, 1, 3, 2, 5, 8},
+	}
+)
+
+// verhoeffValid checks the Verhoeff check digit Aadhaar numbers end with
+func verhoeffValid(digits string) bool {
+	c := 0
+	for i := 0; i < len(digits); i++ {
+		d := digits[len(digits)-1-i] - '0'
+		if d > 9 {
+			return false
+		}
+		c = verhoeffMultiply[c][verhoeffPermute[i%8][d]]
+	}
+	return c == 0
+}
+
+func kycApplicationKey(applicationID string) string {
+	return kycPrefix + applicationID + "/application.json"
+}
+
+func kycArtifactKey(applicationID, name string) string {
+	return kycPrefix + applicationID + "/" + name
+}
+
+func kycIdentityKey(identifierHash string) string {
+	return kycPrefix + "identities/" + identifierHash
+}
+
+// kycDIDKey lists a DID's application IDs, one per line
+func kycDIDKey(digitalID string) string {
+	return kycPrefix + "dids/" + digitalID
+}
+
+// load reads an application from the evidence store, or nil when there is none
+func (k *kycService) load(ctx context.Context, applicationID string) (*KYCApplication, error) {
+	body, err := evidenceStore.Get(ctx, kycApplicationKey(applicationID))
+	if errors.Is(err, errObjectNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	var app KYCApplication
+	if err := json.NewDecoder(body).Decode(&app); err != nil {
+		return nil, &malformedDocumentError{kind: "KYC application", err: err}
+	}
+	return &app, nil
+}
+
+func (k *kycService) save(ctx context.Context, app *KYCApplication) error {
+	app.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	data, err := json.Marshal(app)
+	if err != nil {
+		return err
+	}
+	return evidenceStore.Put(ctx, kycApplicationKey(app.ApplicationID), bytes.NewReader(data), int64(len(data)), "application/json")
+}
+
+// existing returns the identity's latest application
+func (k *kycService) existing(ctx context.Context, identifierHash string) (*KYCApplication, error) {
+	body, err := evidenceStore.Get(ctx, kycIdentityKey(identifierHash))
+	if errors.Is(err, errObjectNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	id, err := io.ReadAll(io.LimitReader(body, 256))
+	if err != nil {
+		return nil, err
+	}
+	return k.load(ctx, string(id))
+}
+
+// submit records an application and its artifacts, then issues its DID when the verifier vouches
+// for it or queues it for review otherwise. A verified application whose DID could not be issued
+// is returned with the error and can be approved later.
+func (k *kycService) submit(ctx context.Context, req KYCApplicationRequest, uploads []kycUpload) (*KYCApplication, *TransactionResult, error) {
+	identifier := k.identifierHash(req)
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	previous, err := k.existing(ctx, identifier)
+	if err != nil {
+		return nil, nil, err
+	}
+	if previous != nil && previous.Status != kycRejected {
+		return previous, nil, errKYCDuplicate
+	}
+
+	now := time.Now().UTC()
+	suffix, salt := make([]byte, 4), make([]byte, 16)
+	rand.Read(suffix)
+	rand.Read(salt)
+	app := &KYCApplication{
+		ApplicationID:    fmt.Sprintf("KYC-%s-%s", now.Format("20060102T150405Z"), hex.EncodeToString(suffix)),
+		Status:           kycReview,
+		DocumentType:     req.DocumentType,
+		Nationality:      req.Nationality,
+		IdentifierHash:   identifier,
+		MaskedIdentifier: maskIdentifier(req.DocumentNumber),
+		FullName:         strings.TrimSpace(req.FullName),
+		DateOfBirth:      req.DateOfBirth,
+		DigitalID:        k.digitalID(identifier),
+		ExpiresAt:        req.ExpiresAt,
+		Consent:          req.Consent,
+		ConsentSalt:      hex.EncodeToString(salt),
+		Artifacts:        []KYCArtifact{},
+		SubmittedAt:      now.Format(time.RFC3339),
+	}
+	app.ConsentHash = kycConsentHash(app.ConsentSalt, app.DigitalID, app.Consent)
+
+	for _, upload := range uploads {
+		sum := sha256.Sum256(upload.data)
+		if err := evidenceStore.Put(ctx, kycArtifactKey(app.ApplicationID, upload.name), bytes.NewReader(upload.data), int64(len(upload.data)), upload.mediaType); err != nil {
+			return nil, nil, err
+		}
+		app.Artifacts = append(app.Artifacts, KYCArtifact{Name: upload.name, MediaType: upload.mediaType, Size: len(upload.data), SHA256: hex.EncodeToString(sum[:])})
+	}
+
+	app.Verification = KYCVerification{Verifier: "none", Reason: "no verifier is configured", CheckedAt: now.Format(time.RFC3339)}
+	if k.verifier != nil {
+		verified, reason, err := k.verifier.verify(ctx, app, req.DocumentNumber)
+		if err != nil {
+			logWithContext(ctx, "KYC verification of %s failed: %v", app.ApplicationID, err)
+			reason = "verifier unavailable"
+		}
+		app.Verification = KYCVerification{Verifier: k.verifier.name(), Verified: verified, Reason: reason, CheckedAt: time.Now().UTC().Format(time.RFC3339)}
+	}
+	if app.Verification.Verified {
+		app.Status = kycVerified
+	}
+
+	if err := k.save(ctx, app); err != nil {
+		return nil, nil, err
+	}
+	if err := evidenceStore.Put(ctx, kycIdentityKey(identifier), strings.NewReader(app.ApplicationID), int64(len(app.ApplicationID)), "text/plain"); err != nil {
+		return nil, nil, err
+	}
+	ids, err := k.applications(ctx, app.DigitalID)
+	if err != nil {
+		return nil, nil, err
+	}
+	list := strings.Join(append(ids, app.ApplicationID), "\n")
+	if err := evidenceStore.Put(ctx, kycDIDKey(app.DigitalID), strings.NewReader(list), int64(len(list)), "text/plain"); err != nil {
+		return nil, nil, err
+	}
+	if app.Status == kycReview {
+		return app, nil, k.queue.add(ctx, app.ApplicationID, now)
+	}
+	result, err := k.issue(ctx, app)
+	return app, result, err
+}
+
+// issue submits CreateDID for a verified or approved application. A DID that already exists was
+// issued by an earlier attempt, as IDs are derived from the identity.
+func (k *kycService) issue(ctx context.Context, app *KYCApplication) (*TransactionResult, error) {
+	result, err := ledger.CreateDID(ctx, CreateDIDRequest{DigitalID: app.DigitalID, ConsentHash: app.ConsentHash, ExpiresAt: app.ExpiresAt, Issuer: k.issuer})
+	if err != nil && translateFabricError(err).Code != errCodeAlreadyExists {
+		return nil, err
+	}
+	app.Status = kycIssued
+	if result != nil {
+		app.TxID = result.TxID
+	}
+	if err := k.save(ctx, app); err != nil {
+		return result, err
+	}
+	return result, k.queue.remove(ctx, app.ApplicationID)
+}
+
+// review approves or rejects an application that is awaiting review. Approving issues its DID.
+func (k *kycService) review(ctx context.Context, applicationID string, req KYCReviewRequest, approve bool) (*KYCApplication, *TransactionResult, error) {
+	if err := validateMutation(applicationID, req); err != nil {
+		return nil, nil, err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	app, err := k.load(ctx, applicationID)
+	if err != nil || app == nil {
+		return nil, nil, err
+	}
+	if app.Status != kycReview && app.Status != kycVerified {
+		return app, nil, errKYCState
+	}
+	app.ReviewedBy, app.ReviewNote, app.ReviewedAt = req.Reviewer, req.Note, time.Now().UTC().Format(time.RFC3339)
+	if approve {
+		result, err := k.issue(ctx, app)
+		return app, result, err
+	}
+	app.Status = kycRejected
+	if err := k.save(ctx, app); err != nil {
+		return nil, nil, err
+	}
+	return app, nil, k.queue.remove(ctx, app.ApplicationID)
+}
+
+// applications returns the IDs of a DID's applications, oldest first
+func (k *kycService) applications(ctx context.Context, digitalID string) ([]string, error) {
+	body, err := evidenceStore.Get(ctx, kycDIDKey(digitalID))
+	if errors.Is(err, errObjectNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	ids, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(string(ids)), nil
+}
+
+// purgeArtifacts deletes the artifacts of every application for a DID. The applications are kept,
+// with the artifact hashes, so reviews stay auditable.
+func (k *kycService) purgeArtifacts(ctx context.Context, digitalID string) error {
+	_, err := k.purge(ctx, digitalID, false)
+	return err
+}
+
+// erase deletes the artifacts of every application for a DID and blanks the personal details the
+// applications hold, returning how many artifacts and applications it changed. The keyed hashes
+// are kept, as they identify no one without the pepper.
+func (k *kycService) erase(ctx context.Context, digitalID string) (int, error) {
+	return k.purge(ctx, digitalID, true)
+}
+
+func (k *kycService) purge(ctx context.Context, digitalID string, personal bool) (int, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	ids, err := k.applications(ctx, digitalID)
+	if err != nil {
+		return 0, err
+	}
+	purged := 0
+	for _, id := range ids {
+		app, err := k.load(ctx, id)
+		if err != nil {
+			return purged, err
+		}
+		if app == nil || (app.ArtifactsPurgedAt != "" && (!personal || app.ErasedAt != "")) {
+			continue
+		}
+		now := time.Now().UTC().Format(time.RFC3339)
+		if app.ArtifactsPurgedAt == "" {
+			for _, artifact := range app.Artifacts {
+				if err := evidenceStore.Delete(ctx, kycArtifactKey(id, artifact.Name)); err != nil && !errors.Is(err, errObjectNotFound) {
+					return purged, err
+				}
+				purged++
+			}
+			app.ArtifactsPurgedAt = now
+		}
+		if personal && app.ErasedAt == "" {
+			app.FullName, app.DateOfBirth, app.MaskedIdentifier = "", "", ""
+			app.ErasedAt = now
+			purged++
+		}
+		if err := k.save(ctx, app); err != nil {
+			return purged, err
+		}
+	}
+	return purged, nil
+}
+
+// pending lists the review queue, oldest first
+func (k *kycService) pending(ctx context.Context) ([]KYCApplication, error) {
+	ids, err := k.queue.list(ctx, maxKYCReviewList)
+	if err != nil {
+		return nil, err
+	}
+	apps := make([]KYCApplication, 0, len(ids))
+	for _, id := range ids {
+		app, err := k.load(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if app != nil {
+			apps = append(apps, *app)
+		}
+	}
+	return apps, nil
+}
+
+// readKYCSubmission reads the application and its artifacts from a JSON or multipart body. The
+// multipart form holds the application as JSON in an "application" field and the artifacts as
+// files named after kycArtifactNames.
+func readKYCSubmission(c *gin.Context, maxArtifact int64) (KYCApplicationRequest, []kycUpload, error) {
+	var req KYCApplicationRequest
+	mediaType, params, _ := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		return req, nil, c.ShouldBindJSON(&req)
+	}
+
+	body := http.MaxBytesReader(c.Writer, c.Request.Body, maxArtifact*int64(len(kycArtifactNames))+1<<20)
+	reader := multipart.NewReader(body, params["boundary"])
+	var uploads []kycUpload
+	found := false
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return req, nil, err
+		}
+		name := part.FormName()
+		switch {
+		case name == kycApplicationPart:
+			if err := json.NewDecoder(io.LimitReader(part, 1<<20)).Decode(&req); err != nil {
+				return req, nil, ValidationErrors{{Field: kycApplicationPart, Message: "must be a JSON application"}}
+			}
+			found = true
+		case slices.Contains(kycArtifactNames, name):
+			data, err := io.ReadAll(&uploadLimitReader{reader: part, limit: maxArtifact})
+			if err != nil {
+				return req, nil, err
+			}
+			mediaType := sniffMediaTypes(data)[0]
+			if !slices.Contains(kycArtifactTypes, mediaType) {
+				return req, nil, ValidationErrors{{Field: name, Message: "must be one of " + strings.Join(kycArtifactTypes, ", ")}}
+			}
+			if slices.ContainsFunc(uploads, func(u kycUpload) bool { return u.name == name }) {
+				return req, nil, ValidationErrors{{Field: name, Message: "must be sent once"}}
+			}
+			uploads = append(uploads, kycUpload{name: name, mediaType: mediaType, data: data})
+		}
+		part.Close()
+	}
+	if !found {
+		return req, nil, ValidationErrors{{Field: kycApplicationPart, Message: "is required"}}
+	}
+	return req, uploads, nil
+}
+
+func kycDisabled(c *gin.Context) bool {
+	if onboarding == nil {
+		respondError(c, http.StatusServiceUnavailable, errCodeKYCDisabled, "KYC onboarding is disabled; set KYC_ENABLED")
+		return true
+	}
+	return false
+}
+
+// respondKYC answers with the application, including the DID transaction when one was committed
+func respondKYC(c *gin.Context, action string, app *KYCApplication, result *TransactionResult, err error) {
+	switch {
+	case errors.Is(err, errKYCDuplicate):
+		respondError(c, http.StatusConflict, errCodeKYCDuplicate, fmt.Sprintf("This document already has application %s with status %s", app.ApplicationID, app.Status))
+	case errors.Is(err, errKYCState):
+		respondError(c, http.StatusConflict, errCodeKYCState, fmt.Sprintf("Application %s is %s, not awaiting review", app.ApplicationID, app.Status))
+	case err != nil:
+		respondServiceError(c, action, err)
+	case app == nil:
+		respondError(c, http.StatusNotFound, errCodeNotFound, "KYC application not found")
+	case app.Status == kycIssued:
+		respondCommitted(c, http.StatusCreated, app, result)
+	case app.Status == kycRejected:
+		respondData(c, http.StatusOK, app)
+	default:
+		respondData(c, http.StatusAccepted, app)
+	}
+}
+
+// submitKYCApplication accepts a tourist's identity document and consent
+func submitKYCApplication(c *gin.Context) {
+	if kycDisabled(c) {
+		return
+	}
+	req, uploads, err := readKYCSubmission(c, onboarding.maxArtifact)
+	if isUploadTooLarge(err) {
+		respondError(c, http.StatusRequestEntityTooLarge, errCodePayloadTooLarge, fmt.Sprintf("Each artifact must not exceed %d bytes", onboarding.maxArtifact))
+		return
+	}
+	var validationErrs ValidationErrors
+	if err != nil && !errors.As(err, &validationErrs) {
+		validationErrs = bindingErrors(err)
+	}
+	if len(validationErrs) == 0 {
+		req.normalize()
+		validationErrs = req.Validate()
+	}
+	if len(validationErrs) > 0 {
+		respondValidationErrors(c, validationErrs)
+		return
+	}
+
+	app, result, err := onboarding.submit(c.Request.Context(), req, uploads)
+	if app != nil {
+		setAuditTarget(c, app.ApplicationID)
+	}
+	respondKYC(c, "Failed to submit KYC application", app, result, err)
+}
+
+func getKYCApplication(c *gin.Context) {
+	if kycDisabled(c) {
+		return
+	}
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	app, err := onboarding.load(c.Request.Context(), id)
+	if err == nil && app != nil {
+		respondData(c, http.StatusOK, app)
+		return
+	}
+	respondKYC(c, "Failed to read KYC application", app, nil, err)
+}
+
+func listKYCReviewQueue(c *gin.Context) {
+	if kycDisabled(c) {
+		return
+	}
+	apps, err := onboarding.pending(c.Request.Context())
+	if err != nil {
+		respondServiceError(c, "Failed to read the KYC review queue", err)
+		return
+	}
+	respondData(c, http.StatusOK, apps)
+}
+
+func reviewKYCApplication(approve bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if kycDisabled(c) {
+			return
+		}
+		id, ok := validPathID(c, "id")
+		if !ok {
+			return
+		}
+		var req KYCReviewRequest
+		if !bindRequest(c, &req) {
+			return
+		}
+		setAuditTarget(c, id)
+		app, result, err := onboarding.review(c.Request.Context(), id, req, approve)
+		respondKYC(c, "Failed to review KYC application", app, result, err)
+	}
+}
+
+// getKYCArtifact serves an artifact to reviewers after checking it against the recorded hash
+func getKYCArtifact(c *gin.Context) {
+	if kycDisabled(c) {
+		return
+	}
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	app, err := onboarding.load(ctx, id)
+	if err != nil || app == nil {
+		respondKYC(c, "Failed to read KYC application", app, nil, err)
+		return
+	}
+	i := slices.IndexFunc(app.Artifacts, func(a KYCArtifact) bool { return a.Name == c.Param("name") })
+	if i < 0 {
+		respondError(c, http.StatusNotFound, errCodeNotFound, "The application has no such artifact")
+		return
+	}
+	if app.ArtifactsPurgedAt != "" {
+		respondError(c, http.StatusGone, errCodeNotFound, "The artifacts were deleted when the tourist withdrew consent to data retention")
+		return
+	}
+	artifact := app.Artifacts[i]
+	hash, err := hashStoredObject(ctx, kycArtifactKey(id, artifact.Name))
+	if err != nil {
+		respondError(c, http.StatusServiceUnavailable, errCodeUnavailable, "Failed to read the artifact from storage")
+		return
+	}
+	if hash != artifact.SHA256 {
+		respondError(c, http.StatusConflict, errCodeEvidenceTampered, "Stored artifact does not match the hash recorded with the application")
+		return
+	}
+	body, err := evidenceStore.Get(ctx, kycArtifactKey(id, artifact.Name))
+	if err != nil {
+		respondError(c, http.StatusServiceUnavailable, errCodeUnavailable, "Failed to read the artifact from storage")
+		return
+	}
+	defer body.Close()
+	c.DataFromReader(http.StatusOK, int64(artifact.Size), artifact.MediaType, body, map[string]string{
+		"Content-Disposition": fmt.Sprintf(`attachment; filename="%s-%s"`, id, artifact.Name),
+		"Cache-Control":       "no-store",
+	})
+}
+
+// redisReviewQueue is a sorted set of application IDs scored by submission time
+type redisReviewQueue struct {
+	redis *redisClient
+	key   string
+}
+
+func (q redisReviewQueue) add(ctx context.Context, applicationID string, at time.Time) error {
+	_, err := q.redis.Do(ctx, "ZADD", q.key, strconv.FormatInt(at.UnixMilli(), 10), applicationID)
+	return err
+}
+
+func (q redisReviewQueue) remove(ctx context.Context, applicationID string) error {
+	_, err := q.redis.Do(ctx, "ZREM", q.key, applicationID)
+	return err
+}
+
+func (q redisReviewQueue) list(ctx context.Context, limit int) ([]string, error) {
+	reply, err := q.redis.Do(ctx, "ZRANGE", q.key, "0", strconv.Itoa(limit-1))
+	if err != nil {
+		return nil, err
+	}
+	members, _ := reply.([]interface{})
+	ids := make([]string, 0, len(members))
+	for _, member := range members {
+		raw, _ := member.([]byte)
+		ids = append(ids, string(raw))
+	}
+	return ids, nil
+}
+
+// memoryReviewQueue serves a single gateway instance
+type memoryReviewQueue struct {
+	mu    sync.Mutex
+	added map[string]time.Time
+}
+
+func newMemoryReviewQueue() *memoryReviewQueue {
+	return &memoryReviewQueue{added: map[string]time.Time{}}
+}
+
+func (q *memoryReviewQueue) add(_ context.Context, applicationID string, at time.Time) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.added[applicationID] = at
+	return nil
+}
+
+func (q *memoryReviewQueue) remove(_ context.Context, applicationID string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.added, applicationID)
+	return nil
+}
+
+func (q *memoryReviewQueue) list(_ context.Context, limit int) ([]string, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	ids := sortedKeys(q.added)
+	slices.SortStableFunc(ids, func(a, b string) int { return q.added[a].Compare(q.added[b]) })
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+	return ids, nil
+}
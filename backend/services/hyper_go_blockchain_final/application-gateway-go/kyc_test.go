@@ -0,0 +1,112 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// unverifiedKYC fails every verification, sending applications to review
+type unverifiedKYC struct{ numbers []string }
+
+func (u *unverifiedKYC) name() string { return "test" }
+
+func (u *unverifiedKYC) verify(_ context.Context, _ *KYCApplication, documentNumber string) (bool, string, error) {
+	u.numbers = append(u.numbers, documentNumber)
+	return false, "name mismatch", nil
+}
+
+func TestKYCApplicationValidation(t *testing.T) {
+	expires := time.Now().Add(30 * 24 * time.Hour).UTC().Format(time.RFC3339)
+	req := KYCApplicationRequest{
+		DocumentType: "aadhaar", DocumentNumber: "2341 2341 2346", FullName: "Asha Devi", DateOfBirth: "1990-04-01",
+		ExpiresAt: expires, Consent: KYCConsent{Purposes: []string{"location_tracking"}, PolicyVersion: "v1"},
+	}
+	req.normalize()
+	if errs := req.Validate(); len(errs) > 0 || req.DocumentNumber != "234123412346" || req.Nationality != "IN" {
+		t.Fatalf("expected a valid Aadhaar application, got %v for %+v", errs, req)
+	}
+	req.DocumentNumber = "234123412345"
+	if errs := req.Validate(); len(errs) != 1 || errs[0].Field != "documentNumber" {
+		t.Errorf("expected the Verhoeff check digit to be enforced, got %v", errs)
+	}
+
+	passport := KYCApplicationRequest{
+		DocumentType: "passport", DocumentNumber: "k1234567", Nationality: "in", FullName: "Asha Devi", DateOfBirth: "1990-04-01",
+		ExpiresAt: expires, Consent: KYCConsent{Purposes: []string{"location_tracking"}, PolicyVersion: "v1"},
+	}
+	passport.normalize()
+	if errs := passport.Validate(); len(errs) > 0 {
+		t.Errorf("expected a valid Indian passport, got %v", errs)
+	}
+	passport.DocumentNumber = "12345678"
+	if errs := passport.Validate(); len(errs) != 1 {
+		t.Errorf("expected Indian passports to start with a letter, got %v", errs)
+	}
+}
+
+func TestKYCReviewQueue(t *testing.T) {
+	store, err := newLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := evidenceStore
+	evidenceStore = store
+	defer func() { evidenceStore = previous }()
+	ctx := context.Background()
+
+	verifier := &unverifiedKYC{}
+	k := &kycService{pepper: []byte("0123456789abcdef"), issuer: "kyc-onboarding", didMethod: "sih", verifier: verifier, queue: newMemoryReviewQueue()}
+	req := KYCApplicationRequest{
+		DocumentType: "aadhaar", DocumentNumber: "234123412346", Nationality: "IN", FullName: "Asha Devi", DateOfBirth: "1990-04-01",
+		ExpiresAt: time.Now().Add(time.Hour).UTC().Format(time.RFC3339), Consent: KYCConsent{Purposes: []string{"location_tracking", "family_sharing"}, PolicyVersion: "v1"},
+	}
+	selfie := kycUpload{name: "selfie", mediaType: "image/png", data: []byte("\x89PNG\r\n\x1a\nselfie")}
+
+	app, result, err := k.submit(ctx, req, []kycUpload{selfie})
+	if err != nil || result != nil || app.Status != kycReview || app.Verification.Reason != "name mismatch" {
+		t.Fatalf("expected the failed verification to be queued for review, got %+v, %v", app, err)
+	}
+	if app.MaskedIdentifier != "XXXXXXXX2346" || !strings.HasPrefix(app.DigitalID, "did:sih:") || len(app.Artifacts) != 1 {
+		t.Errorf("unexpected application %+v", app)
+	}
+	if app.ConsentHash != kycConsentHash(app.ConsentSalt, app.DigitalID, KYCConsent{Purposes: []string{"family_sharing", "location_tracking"}, PolicyVersion: "v1"}) {
+		t.Errorf("expected the consent hash not to depend on purpose order")
+	}
+	if hash, err := hashStoredObject(ctx, kycArtifactKey(app.ApplicationID, "selfie")); err != nil || hash != app.Artifacts[0].SHA256 {
+		t.Errorf("expected the artifact to be stored under its recorded hash, got %s, %v", hash, err)
+	}
+
+	// The number went to the verifier but was not stored
+	stored, _ := k.load(ctx, app.ApplicationID)
+	if len(verifier.numbers) != 1 || stored == nil || stored.IdentifierHash != app.IdentifierHash {
+		t.Fatalf("expected the application to be stored, got %+v", stored)
+	}
+
+	// The same identity cannot apply twice while the first application is pending
+	if _, _, err := k.submit(ctx, req, nil); !errors.Is(err, errKYCDuplicate) {
+		t.Fatalf("expected a duplicate application to be refused, got %v", err)
+	}
+	if pending, _ := k.pending(ctx); len(pending) != 1 || pending[0].ApplicationID != app.ApplicationID {
+		t.Fatalf("expected one application awaiting review, got %+v", pending)
+	}
+
+	rejected, _, err := k.review(ctx, app.ApplicationID, KYCReviewRequest{Reviewer: "officer_1", Note: "photo does not match"}, false)
+	if err != nil || rejected.Status != kycRejected || rejected.ReviewedBy != "officer_1" {
+		t.Fatalf("expected the application to be rejected, got %+v, %v", rejected, err)
+	}
+	if _, _, err := k.review(ctx, app.ApplicationID, KYCReviewRequest{Reviewer: "officer_2"}, true); !errors.Is(err, errKYCState) {
+		t.Errorf("expected a rejected application not to be approved, got %v", err)
+	}
+	if pending, _ := k.pending(ctx); len(pending) != 0 {
+		t.Errorf("expected the review queue to be empty, got %+v", pending)
+	}
+
+	// After a rejection the tourist may apply again, and gets the same DID
+	again, _, err := k.submit(ctx, req, nil)
+	if err != nil || again.ApplicationID == app.ApplicationID || again.DigitalID != app.DigitalID {
+		t.Errorf("expected a new application for the same DID, got %+v, %v", again, err)
+	}
+}
@@ -0,0 +1,967 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"github.com/hyperledger/fabric-protos-go-apiv2/msp"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer/lifecycle"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+)
+
+// chaincodeCommands are the sih-network steps in the order "deploy" runs them; rollback is separate
+var chaincodeCommands = []string{"package", "install", "approve", "commit"}
+
+const (
+	lifecycleChaincode = "_lifecycle"
+	endorsementPlugin  = "escc"
+	validationPlugin   = "vscc"
+	installTimeout     = 10 * time.Minute
+	// defaultEndorsementPolicy is what the lifecycle applies when a definition names no policy
+	defaultEndorsementPolicy = "/Channel/Application/Endorsement"
+)
+
+var (
+	chaincodeNameRegex  = regexp.MustCompile(`^[a-zA-Z0-9]+([-_][a-zA-Z0-9]+)*$`)
+	chaincodeLabelRegex = regexp.MustCompile(`^[[:alnum:]][[:alnum:]_.+-]*$`)
+	versionRegex        = regexp.MustCompile(`^[A-Za-z0-9_.+-]+$`)
+)
+
+// networkChaincode is the chaincode sih-network deploys to the channel. Label defaults to
+// <name>_<version>, Package to <label>.tar.gz and History to <channel>-<name>-history.json,
+// both beside the channel block.
+type networkChaincode struct {
+	Name                string `json:"name"`
+	Version             string `json:"version"`
+	Path                string `json:"path"`
+	Label               string `json:"label,omitempty"`
+	Package             string `json:"package,omitempty"`
+	History             string `json:"history,omitempty"`
+	SignaturePolicy     string `json:"signaturePolicy,omitempty"`
+	ChannelConfigPolicy string `json:"channelConfigPolicy,omitempty"`
+	Collections         string `json:"collections,omitempty"`
+	InitRequired        bool   `json:"initRequired,omitempty"`
+}
+
+func (cc *networkChaincode) setDefaults(t *networkTopology) {
+	if cc.Label == "" {
+		cc.Label = cc.Name + "_" + cc.Version
+	}
+	if cc.Package == "" {
+		cc.Package = filepath.Join(filepath.Dir(t.Block), cc.Label+".tar.gz")
+	}
+	if cc.History == "" {
+		cc.History = filepath.Join(filepath.Dir(t.Block), t.Channel+"-"+cc.Name+"-history.json")
+	}
+}
+
+func (cc *networkChaincode) validate() error {
+	switch {
+	case !chaincodeNameRegex.MatchString(cc.Name):
+		return fmt.Errorf("chaincode name %q must be letters and digits separated by '-' or '_'", cc.Name)
+	case !versionRegex.MatchString(cc.Version):
+		return fmt.Errorf("chaincode version %q must be letters, digits, '_', '.', '+' and '-'", cc.Version)
+	case !chaincodeLabelRegex.MatchString(cc.Label):
+		return fmt.Errorf("chaincode label %q must start with a letter or digit", cc.Label)
+	case cc.Path == "":
+		return errors.New("chaincode path is required")
+	case cc.SignaturePolicy != "" && cc.ChannelConfigPolicy != "":
+		return errors.New("set signaturePolicy or channelConfigPolicy, not both")
+	}
+	if cc.SignaturePolicy != "" {
+		if _, err := signaturePolicyFromString(cc.SignaturePolicy); err != nil {
+			return fmt.Errorf("chaincode signature policy: %w", err)
+		}
+	}
+	return nil
+}
+
+// chaincodeDefinition is a chaincode definition as the organizations approve it. The history file
+// keeps one per committed sequence, which is what rollback returns to.
+type chaincodeDefinition struct {
+	Sequence            int64  `json:"sequence"`
+	Version             string `json:"version"`
+	PackageID           string `json:"packageID"`
+	ValidationParameter []byte `json:"validationParameter"`
+	Collections         []byte `json:"collections,omitempty"`
+	InitRequired        bool   `json:"initRequired,omitempty"`
+	CommittedAt         string `json:"committedAt,omitempty"`
+}
+
+func (d chaincodeDefinition) collections() (*peer.CollectionConfigPackage, error) {
+	collections := &peer.CollectionConfigPackage{}
+	return collections, proto.Unmarshal(d.Collections, collections)
+}
+
+// matchesCommitted reports whether the committed definition is this one, apart from the package,
+// which only the approvals name
+func (d chaincodeDefinition) matchesCommitted(committed *lifecycle.QueryChaincodeDefinitionResult) bool {
+	collections, err := d.collections()
+	return err == nil && committed.GetVersion() == d.Version &&
+		committed.GetEndorsementPlugin() == endorsementPlugin && committed.GetValidationPlugin() == validationPlugin &&
+		bytes.Equal(committed.GetValidationParameter(), d.ValidationParameter) &&
+		proto.Equal(nonNilCollections(committed.GetCollections()), collections) && committed.GetInitRequired() == d.InitRequired
+}
+
+// matchesApproved reports whether an organization's approval is for this definition and package
+func (d chaincodeDefinition) matchesApproved(approved *lifecycle.QueryApprovedChaincodeDefinitionResult) bool {
+	collections, err := d.collections()
+	return err == nil && approved.GetSequence() == d.Sequence && approved.GetVersion() == d.Version &&
+		approved.GetSource().GetLocalPackage().GetPackageId() == d.PackageID &&
+		approved.GetEndorsementPlugin() == endorsementPlugin && approved.GetValidationPlugin() == validationPlugin &&
+		bytes.Equal(approved.GetValidationParameter(), d.ValidationParameter) &&
+		proto.Equal(nonNilCollections(approved.GetCollections()), collections) && approved.GetInitRequired() == d.InitRequired
+}
+
+func nonNilCollections(c *peer.CollectionConfigPackage) *peer.CollectionConfigPackage {
+	if c == nil {
+		return &peer.CollectionConfigPackage{}
+	}
+	return c
+}
+
+// desiredDefinition builds the package and the definition the topology asks for, at sequence 0
+// until the channel's state decides it
+func (b *networkBootstrap) desiredDefinition() (chaincodeDefinition, []byte, error) {
+	t, cc := b.topology, b.topology.Chaincode
+	pkg, err := packageChaincode(t.path(cc.Path), cc.Label)
+	if err != nil {
+		return chaincodeDefinition{}, nil, fmt.Errorf("failed to package %s: %w", cc.Path, err)
+	}
+	policy := &peer.ApplicationPolicy{Type: &peer.ApplicationPolicy_ChannelConfigPolicyReference{ChannelConfigPolicyReference: defaultEndorsementPolicy}}
+	if cc.ChannelConfigPolicy != "" {
+		policy.Type = &peer.ApplicationPolicy_ChannelConfigPolicyReference{ChannelConfigPolicyReference: cc.ChannelConfigPolicy}
+	}
+	if cc.SignaturePolicy != "" {
+		envelope, _ := signaturePolicyFromString(cc.SignaturePolicy)
+		policy.Type = &peer.ApplicationPolicy_SignaturePolicy{SignaturePolicy: envelope}
+	}
+	validationParameter, err := proto.Marshal(policy)
+	if err != nil {
+		return chaincodeDefinition{}, nil, err
+	}
+	collections := &peer.CollectionConfigPackage{}
+	if cc.Collections != "" {
+		if collections, err = loadCollectionConfig(t.path(cc.Collections)); err != nil {
+			return chaincodeDefinition{}, nil, err
+		}
+	}
+	collectionBytes, err := proto.Marshal(collections)
+	if err != nil {
+		return chaincodeDefinition{}, nil, err
+	}
+	return chaincodeDefinition{
+		Version:             cc.Version,
+		PackageID:           chaincodePackageID(cc.Label, pkg),
+		ValidationParameter: validationParameter,
+		Collections:         collectionBytes,
+		InitRequired:        cc.InitRequired,
+	}, pkg, nil
+}
+
+// packageChaincode builds a Go chaincode package as peer lifecycle chaincode package does: a
+// metadata.json naming the main package and a code.tar.gz with the module under src/ and any
+// META-INF statedb indexes at the top. Entries are sorted and undated, so the same source always
+// gives the same package ID.
+func packageChaincode(dir, label string) ([]byte, error) {
+	importPath, err := goModulePath(dir)
+	if err != nil {
+		return nil, err
+	}
+	var code bytes.Buffer
+	gz := gzip.NewWriter(&code)
+	tw := tar.NewWriter(gz)
+	err = filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if path != dir && strings.HasPrefix(entry.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if !strings.HasPrefix(name, "META-INF/") {
+			name = "src/" + name
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return writeTarFile(tw, name, data)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+
+	metadata, err := json.Marshal(struct {
+		Path  string `json:"path"`
+		Type  string `json:"type"`
+		Label string `json:"label"`
+	}{importPath, "golang", label})
+	if err != nil {
+		return nil, err
+	}
+	var pkg bytes.Buffer
+	gz = gzip.NewWriter(&pkg)
+	tw = tar.NewWriter(gz)
+	if err := writeTarFile(tw, "metadata.json", metadata); err != nil {
+		return nil, err
+	}
+	if err := writeTarFile(tw, "code.tar.gz", code.Bytes()); err != nil {
+		return nil, err
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return pkg.Bytes(), nil
+}
+
+func writeTarFile(tw *tar.Writer, name string, data []byte) error {
+	header := &tar.Header{Typeflag: tar.TypeReg, Name: name, Size: int64(len(data)), Mode: 0o100644, ModTime: time.Unix(0, 0)}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+// goModulePath reads the module path from go.mod; the chaincode's main package is at the module root
+func goModulePath(dir string) (string, error) {
+	file, err := os.Open(filepath.Join(dir, "go.mod"))
+	if err != nil {
+		return "", fmt.Errorf("chaincode path must be a Go module: %w", err)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if module, ok := strings.CutPrefix(strings.TrimSpace(scanner.Text()), "module "); ok {
+			return strings.Trim(strings.TrimSpace(module), `"`), nil
+		}
+	}
+	return "", errors.New("go.mod has no module directive")
+}
+
+// chaincodePackageID is the ID peers give an installed package
+func chaincodePackageID(label string, pkg []byte) string {
+	hash := sha256.Sum256(pkg)
+	return label + ":" + hex.EncodeToString(hash[:])
+}
+
+// signaturePolicyFromString parses Fabric's policy language, for example
+// OR('Org1MSP.peer', AND('Org2MSP.admin', 'Org3MSP.member')) or OutOf(2, 'A.peer', 'B.peer', 'C.peer').
+// Like the peer CLI, each principal gets its own identity in order of appearance, so the policy
+// encodes to the same bytes as one approved with --signature-policy.
+func signaturePolicyFromString(text string) (*common.SignaturePolicyEnvelope, error) {
+	p := &policyParser{text: text}
+	rule, err := p.rule()
+	if err != nil {
+		return nil, err
+	}
+	if p.skipSpace(); p.pos != len(p.text) {
+		return nil, fmt.Errorf("unexpected %q at %d", p.text[p.pos:], p.pos)
+	}
+	return &common.SignaturePolicyEnvelope{Rule: rule, Identities: p.identities}, nil
+}
+
+type policyParser struct {
+	text       string
+	pos        int
+	identities []*msp.MSPPrincipal
+}
+
+var policyRoles = map[string]msp.MSPRole_MSPRoleType{
+	"member": msp.MSPRole_MEMBER, "admin": msp.MSPRole_ADMIN, "client": msp.MSPRole_CLIENT, "peer": msp.MSPRole_PEER, "orderer": msp.MSPRole_ORDERER,
+}
+
+func (p *policyParser) skipSpace() {
+	for p.pos < len(p.text) && strings.ContainsRune(" \t\r\n", rune(p.text[p.pos])) {
+		p.pos++
+	}
+}
+
+func (p *policyParser) rule() (*common.SignaturePolicy, error) {
+	p.skipSpace()
+	if p.pos < len(p.text) && (p.text[p.pos] == '\'' || p.text[p.pos] == '"') {
+		return p.principal()
+	}
+	start := p.pos
+	for p.pos < len(p.text) && (p.text[p.pos] >= 'A' && p.text[p.pos] <= 'Z' || p.text[p.pos] >= 'a' && p.text[p.pos] <= 'z') {
+		p.pos++
+	}
+	operator := strings.ToLower(p.text[start:p.pos])
+	if operator != "and" && operator != "or" && operator != "outof" {
+		return nil, fmt.Errorf("expected AND, OR, OutOf or a quoted principal at %d", start)
+	}
+	if p.skipSpace(); p.pos >= len(p.text) || p.text[p.pos] != '(' {
+		return nil, fmt.Errorf("expected ( after %s", p.text[start:p.pos])
+	}
+	p.pos++
+
+	n := -1
+	if operator == "outof" {
+		p.skipSpace()
+		digits := p.pos
+		for p.pos < len(p.text) && p.text[p.pos] >= '0' && p.text[p.pos] <= '9' {
+			p.pos++
+		}
+		var err error
+		if n, err = strconv.Atoi(p.text[digits:p.pos]); err != nil {
+			return nil, fmt.Errorf("OutOf needs a count at %d", digits)
+		}
+		if p.skipSpace(); p.pos >= len(p.text) || p.text[p.pos] != ',' {
+			return nil, fmt.Errorf("expected , after the OutOf count at %d", p.pos)
+		}
+		p.pos++
+	}
+	var rules []*common.SignaturePolicy
+	for {
+		rule, err := p.rule()
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+		p.skipSpace()
+		if p.pos < len(p.text) && p.text[p.pos] == ',' {
+			p.pos++
+			continue
+		}
+		if p.pos < len(p.text) && p.text[p.pos] == ')' {
+			p.pos++
+			break
+		}
+		return nil, fmt.Errorf("expected , or ) at %d", p.pos)
+	}
+	switch operator {
+	case "and":
+		n = len(rules)
+	case "or":
+		n = 1
+	}
+	if n < 1 || n > len(rules) {
+		return nil, fmt.Errorf("OutOf(%d) needs between 1 and %d", n, len(rules))
+	}
+	return &common.SignaturePolicy{Type: &common.SignaturePolicy_NOutOf_{NOutOf: &common.SignaturePolicy_NOutOf{N: int32(n), Rules: rules}}}, nil
+}
+
+func (p *policyParser) principal() (*common.SignaturePolicy, error) {
+	quote := p.text[p.pos]
+	end := strings.IndexByte(p.text[p.pos+1:], quote)
+	if end < 0 {
+		return nil, fmt.Errorf("unterminated principal at %d", p.pos)
+	}
+	principal := p.text[p.pos+1 : p.pos+1+end]
+	p.pos += end + 2
+	dot := strings.LastIndexByte(principal, '.')
+	role, ok := policyRoles[principal[dot+1:]]
+	if dot < 1 || !ok {
+		return nil, fmt.Errorf("principal %q must be <MSP ID>.<member|admin|client|peer|orderer>", principal)
+	}
+	encoded, err := proto.Marshal(&msp.MSPRole{MspIdentifier: principal[:dot], Role: role})
+	if err != nil {
+		return nil, err
+	}
+	p.identities = append(p.identities, &msp.MSPPrincipal{PrincipalClassification: msp.MSPPrincipal_ROLE, Principal: encoded})
+	return &common.SignaturePolicy{Type: &common.SignaturePolicy_SignedBy{SignedBy: int32(len(p.identities) - 1)}}, nil
+}
+
+// collectionJSON is one private data collection in the peer CLI's collections config format
+type collectionJSON struct {
+	Name              string `json:"name"`
+	Policy            string `json:"policy"`
+	RequiredPeerCount int32  `json:"requiredPeerCount"`
+	MaxPeerCount      int32  `json:"maxPeerCount"`
+	BlockToLive       uint64 `json:"blockToLive"`
+	MemberOnlyRead    bool   `json:"memberOnlyRead"`
+	MemberOnlyWrite   bool   `json:"memberOnlyWrite"`
+	EndorsementPolicy *struct {
+		SignaturePolicy     string `json:"signaturePolicy"`
+		ChannelConfigPolicy string `json:"channelConfigPolicy"`
+	} `json:"endorsementPolicy,omitempty"`
+}
+
+func loadCollectionConfig(filename string) (*peer.CollectionConfigPackage, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read collections config: %w", err)
+	}
+	var collections []collectionJSON
+	if err := json.Unmarshal(data, &collections); err != nil {
+		return nil, fmt.Errorf("invalid collections config: %w", err)
+	}
+	pkg := &peer.CollectionConfigPackage{}
+	for _, c := range collections {
+		members, err := signaturePolicyFromString(c.Policy)
+		if err != nil {
+			return nil, fmt.Errorf("collection %q policy: %w", c.Name, err)
+		}
+		static := &peer.StaticCollectionConfig{
+			Name:              c.Name,
+			MemberOrgsPolicy:  &peer.CollectionPolicyConfig{Payload: &peer.CollectionPolicyConfig_SignaturePolicy{SignaturePolicy: members}},
+			RequiredPeerCount: c.RequiredPeerCount,
+			MaximumPeerCount:  c.MaxPeerCount,
+			BlockToLive:       c.BlockToLive,
+			MemberOnlyRead:    c.MemberOnlyRead,
+			MemberOnlyWrite:   c.MemberOnlyWrite,
+		}
+		if e := c.EndorsementPolicy; e != nil && e.SignaturePolicy != "" {
+			envelope, err := signaturePolicyFromString(e.SignaturePolicy)
+			if err != nil {
+				return nil, fmt.Errorf("collection %q endorsement policy: %w", c.Name, err)
+			}
+			static.EndorsementPolicy = &peer.ApplicationPolicy{Type: &peer.ApplicationPolicy_SignaturePolicy{SignaturePolicy: envelope}}
+		} else if e != nil && e.ChannelConfigPolicy != "" {
+			static.EndorsementPolicy = &peer.ApplicationPolicy{Type: &peer.ApplicationPolicy_ChannelConfigPolicyReference{ChannelConfigPolicyReference: e.ChannelConfigPolicy}}
+		}
+		pkg.Config = append(pkg.Config, &peer.CollectionConfig{Payload: &peer.CollectionConfig_StaticCollectionConfig{StaticCollectionConfig: static}})
+	}
+	return pkg, nil
+}
+
+// lifecyclePeer is an organization's peer with its admin, which the lifecycle requires for
+// installs and approvals
+type lifecyclePeer struct {
+	org   networkPeerOrg
+	peer  networkPeer
+	admin *networkAdmin
+	conn  *grpc.ClientConn
+}
+
+// lifecyclePeers dials every peer in the topology; the first of each organization endorses its
+// approvals and the commit
+func (b *networkBootstrap) lifecyclePeers() ([]*lifecyclePeer, func(), error) {
+	var peers []*lifecyclePeer
+	closeAll := func() {
+		for _, p := range peers {
+			p.conn.Close()
+		}
+	}
+	for _, org := range b.topology.Orgs {
+		admin, err := loadNetworkAdmin(b.topology, org)
+		if err != nil {
+			closeAll()
+			return nil, nil, err
+		}
+		for _, p := range org.Peers {
+			conn, err := dialPeer(b.topology, org, p)
+			if err != nil {
+				closeAll()
+				return nil, nil, fmt.Errorf("%s: %w", p.Name, err)
+			}
+			peers = append(peers, &lifecyclePeer{org: org, peer: p, admin: admin, conn: conn})
+		}
+	}
+	return peers, closeAll, nil
+}
+
+// orgPeers returns the first peer of each organization
+func orgPeers(peers []*lifecyclePeer) []*lifecyclePeer {
+	var first []*lifecyclePeer
+	for _, p := range peers {
+		if len(first) == 0 || first[len(first)-1].org.MSPID != p.org.MSPID {
+			first = append(first, p)
+		}
+	}
+	return first
+}
+
+func (p *lifecyclePeer) query(ctx context.Context, channel, function string, args, result proto.Message) error {
+	input, err := proto.Marshal(args)
+	if err != nil {
+		return err
+	}
+	payload, err := invokeSystemChaincode(ctx, p.conn, p.admin, channel, lifecycleChaincode, []byte(function), input)
+	if err != nil {
+		return err
+	}
+	return proto.Unmarshal(payload, result)
+}
+
+func (p *lifecyclePeer) installedPackages(ctx context.Context) ([]string, error) {
+	var result lifecycle.QueryInstalledChaincodesResult
+	if err := p.query(ctx, "", "QueryInstalledChaincodes", &lifecycle.QueryInstalledChaincodesArgs{}, &result); err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, installed := range result.GetInstalledChaincodes() {
+		ids = append(ids, installed.GetPackageId())
+	}
+	return ids, nil
+}
+
+// committedDefinition returns the chaincode's committed definition, or nil before the first commit
+func (p *lifecyclePeer) committedDefinition(ctx context.Context, channel, name string) (*lifecycle.QueryChaincodeDefinitionResult, error) {
+	var result lifecycle.QueryChaincodeDefinitionResult
+	err := p.query(ctx, channel, "QueryChaincodeDefinition", &lifecycle.QueryChaincodeDefinitionArgs{Name: name}, &result)
+	if err != nil && strings.Contains(err.Error(), "is not defined") {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// approvedDefinition returns the organization's approval for the sequence, or nil if it has none
+func (p *lifecyclePeer) approvedDefinition(ctx context.Context, channel, name string, sequence int64) (*lifecycle.QueryApprovedChaincodeDefinitionResult, error) {
+	var result lifecycle.QueryApprovedChaincodeDefinitionResult
+	err := p.query(ctx, channel, "QueryApprovedChaincodeDefinition", &lifecycle.QueryApprovedChaincodeDefinitionArgs{Name: name, Sequence: sequence}, &result)
+	if err != nil && strings.Contains(err.Error(), "could not fetch approved chaincode definition") {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// runChaincode runs a lifecycle command. Each step reads the channel first and skips what is
+// already done; with -dry-run it only reports what it would do.
+func (b *networkBootstrap) runChaincode(ctx context.Context, commands []string) error {
+	t, cc := b.topology, b.topology.Chaincode
+	if cc == nil {
+		return errors.New("the topology has no chaincode")
+	}
+	desired, pkg, err := b.desiredDefinition()
+	if err != nil {
+		return err
+	}
+	log.Printf("📦 %s is package %s", cc.Path, desired.PackageID)
+	if slices.Contains(commands, "package") {
+		if err := b.writePackage(pkg); err != nil {
+			return err
+		}
+	}
+	if !slices.ContainsFunc(commands, func(c string) bool { return c != "package" }) {
+		return nil
+	}
+
+	peers, closePeers, err := b.lifecyclePeers()
+	if err != nil {
+		return err
+	}
+	defer closePeers()
+	if slices.Contains(commands, "install") {
+		if err := b.installPackage(ctx, peers, desired.PackageID, pkg); err != nil {
+			return err
+		}
+	}
+	if !slices.Contains(commands, "approve") && !slices.Contains(commands, "commit") {
+		return nil
+	}
+
+	committed, err := peers[0].committedDefinition(ctx, t.Channel, cc.Name)
+	if err != nil {
+		return fmt.Errorf("failed to query the committed definition: %w", err)
+	}
+	desired.Sequence = 1
+	if committed != nil {
+		if desired.matchesCommitted(committed) {
+			current := desired
+			current.Sequence = committed.GetSequence()
+			if b.allApproved(ctx, peers, current) {
+				log.Printf("⏭️  %s sequence %d already runs %s", cc.Name, current.Sequence, current.PackageID)
+				return nil
+			}
+		}
+		desired.Sequence = committed.GetSequence() + 1
+		if err := b.rememberCommitted(ctx, peers, committed); err != nil {
+			return err
+		}
+		log.Printf("⬆️  Upgrading %s from version %s sequence %d to version %s sequence %d; sih-network rollback returns to the current definition",
+			cc.Name, committed.GetVersion(), committed.GetSequence(), desired.Version, desired.Sequence)
+	}
+	return b.approveAndCommit(ctx, peers, desired, slices.Contains(commands, "approve"), slices.Contains(commands, "commit"))
+}
+
+func (b *networkBootstrap) writePackage(pkg []byte) error {
+	path := b.topology.path(b.topology.Chaincode.Package)
+	if existing, err := os.ReadFile(path); err == nil && bytes.Equal(existing, pkg) {
+		return nil
+	}
+	if b.dryRun {
+		log.Printf("📝 Would write %s", path)
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, pkg, 0o644); err != nil {
+		return err
+	}
+	log.Printf("📦 Wrote %s", path)
+	return nil
+}
+
+// installPackage installs the package on every peer that does not have it
+func (b *networkBootstrap) installPackage(ctx context.Context, peers []*lifecyclePeer, packageID string, pkg []byte) error {
+	for _, p := range peers {
+		installed, err := p.installedPackages(ctx)
+		if err != nil {
+			return fmt.Errorf("%s: %w", p.peer.Name, err)
+		}
+		if slices.Contains(installed, packageID) {
+			log.Printf("⏭️  %s already has %s", p.peer.Name, packageID)
+			continue
+		}
+		if b.dryRun {
+			log.Printf("📝 Would install %s on %s", packageID, p.peer.Name)
+			continue
+		}
+		input, err := proto.Marshal(&lifecycle.InstallChaincodeArgs{ChaincodeInstallPackage: pkg})
+		if err != nil {
+			return err
+		}
+		sp, err := newSignedProposal(p.admin, "", lifecycleChaincode, []byte("InstallChaincode"), input)
+		if err != nil {
+			return err
+		}
+		if _, err := processProposal(ctx, p.conn, sp, installTimeout); err != nil {
+			return fmt.Errorf("%s: InstallChaincode %w", p.peer.Name, err)
+		}
+		log.Printf("📥 Installed %s on %s", packageID, p.peer.Name)
+	}
+	return nil
+}
+
+// allApproved reports whether every organization approved exactly this definition and package
+func (b *networkBootstrap) allApproved(ctx context.Context, peers []*lifecyclePeer, d chaincodeDefinition) bool {
+	for _, p := range orgPeers(peers) {
+		approved, err := p.approvedDefinition(ctx, b.topology.Channel, b.topology.Chaincode.Name, d.Sequence)
+		if err != nil || approved == nil || !d.matchesApproved(approved) {
+			return false
+		}
+	}
+	return true
+}
+
+// approveAndCommit approves the definition for each organization that has not, checks every
+// organization is ready and commits it
+func (b *networkBootstrap) approveAndCommit(ctx context.Context, peers []*lifecyclePeer, d chaincodeDefinition, approve, commit bool) error {
+	t, cc := b.topology, b.topology.Chaincode
+	collections, err := d.collections()
+	if err != nil {
+		return err
+	}
+	if approve {
+		for _, p := range orgPeers(peers) {
+			approved, err := p.approvedDefinition(ctx, t.Channel, cc.Name, d.Sequence)
+			if err != nil {
+				return fmt.Errorf("%s: %w", p.org.Name, err)
+			}
+			if approved != nil && d.matchesApproved(approved) {
+				log.Printf("⏭️  %s already approved sequence %d", p.org.Name, d.Sequence)
+				continue
+			}
+			if approved != nil {
+				log.Printf("🔁 %s approved a different definition for sequence %d, approving again", p.org.Name, d.Sequence)
+			}
+			if b.dryRun {
+				log.Printf("📝 Would approve %s version %s sequence %d for %s", cc.Name, d.Version, d.Sequence, p.org.Name)
+				continue
+			}
+			args := &lifecycle.ApproveChaincodeDefinitionForMyOrgArgs{
+				Sequence: d.Sequence, Name: cc.Name, Version: d.Version,
+				EndorsementPlugin: endorsementPlugin, ValidationPlugin: validationPlugin, ValidationParameter: d.ValidationParameter,
+				Collections: collections, InitRequired: d.InitRequired,
+				Source: &lifecycle.ChaincodeSource{Type: &lifecycle.ChaincodeSource_LocalPackage{LocalPackage: &lifecycle.ChaincodeSource_Local{PackageId: d.PackageID}}},
+			}
+			if err := b.submitLifecycle(ctx, p.admin, []*lifecyclePeer{p}, "ApproveChaincodeDefinitionForMyOrg", args); err != nil {
+				return fmt.Errorf("%s approval: %w", p.org.Name, err)
+			}
+			err = b.retry(ctx, "Waiting for the "+p.org.Name+" approval", func() error {
+				approved, err := p.approvedDefinition(ctx, t.Channel, cc.Name, d.Sequence)
+				if err == nil && (approved == nil || !d.matchesApproved(approved)) {
+					err = errors.New("the approval is not committed yet")
+				}
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			log.Printf("✍️  %s approved %s sequence %d", p.org.Name, cc.Name, d.Sequence)
+		}
+	}
+	if !commit {
+		return nil
+	}
+
+	var readiness lifecycle.CheckCommitReadinessResult
+	err = peers[0].query(ctx, t.Channel, "CheckCommitReadiness", &lifecycle.CheckCommitReadinessArgs{
+		Sequence: d.Sequence, Name: cc.Name, Version: d.Version,
+		EndorsementPlugin: endorsementPlugin, ValidationPlugin: validationPlugin, ValidationParameter: d.ValidationParameter,
+		Collections: collections, InitRequired: d.InitRequired,
+	}, &readiness)
+	if err != nil {
+		return fmt.Errorf("commit readiness: %w", err)
+	}
+	var missing []string
+	for _, org := range t.Orgs {
+		if !readiness.GetApprovals()[org.MSPID] {
+			missing = append(missing, org.MSPID)
+		}
+	}
+	if len(missing) > 0 && !b.dryRun {
+		return fmt.Errorf("%s sequence %d is not approved by %s", cc.Name, d.Sequence, strings.Join(missing, ", "))
+	}
+	if b.dryRun {
+		log.Printf("📝 Would commit %s version %s sequence %d (approved so far: %v)", cc.Name, d.Version, d.Sequence, readiness.GetApprovals())
+		return nil
+	}
+
+	endorsers := orgPeers(peers)
+	err = b.submitLifecycle(ctx, endorsers[0].admin, endorsers, "CommitChaincodeDefinition", &lifecycle.CommitChaincodeDefinitionArgs{
+		Sequence: d.Sequence, Name: cc.Name, Version: d.Version,
+		EndorsementPlugin: endorsementPlugin, ValidationPlugin: validationPlugin, ValidationParameter: d.ValidationParameter,
+		Collections: collections, InitRequired: d.InitRequired,
+	})
+	if err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+	for _, p := range endorsers {
+		err := b.retry(ctx, "Waiting for the commit on "+p.peer.Name, func() error {
+			committed, err := p.committedDefinition(ctx, t.Channel, cc.Name)
+			if err == nil && committed.GetSequence() != d.Sequence {
+				err = errors.New("the definition is not committed yet")
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	d.CommittedAt = time.Now().UTC().Format(time.RFC3339)
+	if err := b.appendHistory(d); err != nil {
+		return err
+	}
+	log.Printf("🚀 Committed %s version %s sequence %d", cc.Name, d.Version, d.Sequence)
+	return nil
+}
+
+// submitLifecycle endorses a _lifecycle transaction on the peers and sends it to the orderer
+func (b *networkBootstrap) submitLifecycle(ctx context.Context, admin *networkAdmin, endorsers []*lifecyclePeer, function string, args proto.Message) error {
+	input, err := proto.Marshal(args)
+	if err != nil {
+		return err
+	}
+	sp, err := newSignedProposal(admin, b.topology.Channel, lifecycleChaincode, []byte(function), input)
+	if err != nil {
+		return err
+	}
+	var responses []*peer.ProposalResponse
+	for _, p := range endorsers {
+		resp, err := processProposal(ctx, p.conn, sp, time.Minute)
+		if err != nil {
+			return fmt.Errorf("%s: %s %w", p.peer.Name, function, err)
+		}
+		responses = append(responses, resp)
+	}
+	envelope, err := endorsedTransaction(admin, sp, responses)
+	if err != nil {
+		return err
+	}
+	o := b.topology.Orderers[0]
+	conn, err := dialNode(b.topology.path(o.TLSCA), o.Address, o.Name)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return broadcast(ctx, conn, envelope)
+}
+
+// endorsedTransaction assembles the endorsements of a proposal into a signed transaction, which
+// needs every peer to have returned the same result
+func endorsedTransaction(admin *networkAdmin, sp *signedProposal, responses []*peer.ProposalResponse) (*common.Envelope, error) {
+	var endorsements []*peer.Endorsement
+	for _, resp := range responses {
+		if !bytes.Equal(resp.GetPayload(), responses[0].GetPayload()) {
+			return nil, errors.New("the peers returned different results")
+		}
+		endorsements = append(endorsements, resp.GetEndorsement())
+	}
+	action, err := proto.Marshal(&peer.ChaincodeActionPayload{
+		ChaincodeProposalPayload: sp.proposal.GetPayload(),
+		Action:                   &peer.ChaincodeEndorsedAction{ProposalResponsePayload: responses[0].GetPayload(), Endorsements: endorsements},
+	})
+	if err != nil {
+		return nil, err
+	}
+	transaction, err := proto.Marshal(&peer.Transaction{Actions: []*peer.TransactionAction{{Header: sp.header.GetSignatureHeader(), Payload: action}}})
+	if err != nil {
+		return nil, err
+	}
+	payload, err := proto.Marshal(&common.Payload{Header: sp.header, Data: transaction})
+	if err != nil {
+		return nil, err
+	}
+	signature, err := admin.signDigest(payload)
+	if err != nil {
+		return nil, err
+	}
+	return &common.Envelope{Payload: payload, Signature: signature}, nil
+}
+
+func (b *networkBootstrap) loadHistory() ([]chaincodeDefinition, error) {
+	data, err := os.ReadFile(b.topology.path(b.topology.Chaincode.History))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var history []chaincodeDefinition
+	if err := json.Unmarshal(data, &history); err != nil {
+		return nil, fmt.Errorf("invalid chaincode history: %w", err)
+	}
+	return history, nil
+}
+
+// appendHistory records a committed definition, replacing any earlier record of its sequence
+func (b *networkBootstrap) appendHistory(d chaincodeDefinition) error {
+	if b.dryRun {
+		return nil
+	}
+	history, err := b.loadHistory()
+	if err != nil {
+		return err
+	}
+	history = slices.DeleteFunc(history, func(h chaincodeDefinition) bool { return h.Sequence == d.Sequence })
+	history = append(history, d)
+	slices.SortFunc(history, func(a, b chaincodeDefinition) int { return int(a.Sequence - b.Sequence) })
+	data, err := json.MarshalIndent(history, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := b.topology.path(b.topology.Chaincode.History)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// rememberCommitted records the definition an upgrade replaces if the history lacks it, such as
+// one committed by deployCC.sh, taking the package from the first organization's approval
+func (b *networkBootstrap) rememberCommitted(ctx context.Context, peers []*lifecyclePeer, committed *lifecycle.QueryChaincodeDefinitionResult) error {
+	history, err := b.loadHistory()
+	if err != nil {
+		return err
+	}
+	if slices.ContainsFunc(history, func(h chaincodeDefinition) bool { return h.Sequence == committed.GetSequence() }) {
+		return nil
+	}
+	approved, err := peers[0].approvedDefinition(ctx, b.topology.Channel, b.topology.Chaincode.Name, committed.GetSequence())
+	if err != nil {
+		return err
+	}
+	collections, err := proto.Marshal(nonNilCollections(committed.GetCollections()))
+	if err != nil {
+		return err
+	}
+	return b.appendHistory(chaincodeDefinition{
+		Sequence:            committed.GetSequence(),
+		Version:             committed.GetVersion(),
+		PackageID:           approved.GetSource().GetLocalPackage().GetPackageId(),
+		ValidationParameter: committed.GetValidationParameter(),
+		Collections:         collections,
+		InitRequired:        committed.GetInitRequired(),
+	})
+}
+
+// rollbackTarget picks the definition committed before the current sequence
+func rollbackTarget(history []chaincodeDefinition, current int64) (chaincodeDefinition, error) {
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].Sequence < current && history[i].PackageID != "" {
+			return history[i], nil
+		}
+	}
+	return chaincodeDefinition{}, fmt.Errorf("no definition before sequence %d is recorded", current)
+}
+
+// rollback commits the previous definition again at the next sequence. Fabric never lowers a
+// sequence, so a rollback is an upgrade back to the earlier version and package, which must still
+// be installed on every peer.
+func (b *networkBootstrap) rollback(ctx context.Context) error {
+	t, cc := b.topology, b.topology.Chaincode
+	if cc == nil {
+		return errors.New("the topology has no chaincode")
+	}
+	peers, closePeers, err := b.lifecyclePeers()
+	if err != nil {
+		return err
+	}
+	defer closePeers()
+	committed, err := peers[0].committedDefinition(ctx, t.Channel, cc.Name)
+	if err != nil {
+		return err
+	}
+	if committed == nil {
+		return fmt.Errorf("%s is not committed on %s", cc.Name, t.Channel)
+	}
+	if err := b.rememberCommitted(ctx, peers, committed); err != nil {
+		return err
+	}
+	history, err := b.loadHistory()
+	if err != nil {
+		return err
+	}
+	target, err := rollbackTarget(history, committed.GetSequence())
+	if err != nil {
+		return err
+	}
+	for _, p := range peers {
+		installed, err := p.installedPackages(ctx)
+		if err != nil {
+			return fmt.Errorf("%s: %w", p.peer.Name, err)
+		}
+		if !slices.Contains(installed, target.PackageID) {
+			return fmt.Errorf("%s no longer has %s installed; install it before rolling back", p.peer.Name, target.PackageID)
+		}
+	}
+	log.Printf("⏪ Rolling %s back from version %s sequence %d to version %s (sequence %d) as sequence %d",
+		cc.Name, committed.GetVersion(), committed.GetSequence(), target.Version, target.Sequence, committed.GetSequence()+1)
+	target.Sequence, target.CommittedAt = committed.GetSequence()+1, ""
+	return b.approveAndCommit(ctx, peers, target, true, true)
+}
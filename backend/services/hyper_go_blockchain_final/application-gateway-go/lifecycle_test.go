@@ -0,0 +1,203 @@
+package gateway
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric-gateway/pkg/identity"
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"github.com/hyperledger/fabric-protos-go-apiv2/msp"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer/lifecycle"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestSignaturePolicyFromString(t *testing.T) {
+	envelope, err := signaturePolicyFromString("OR('Org1MSP.peer', AND('Org2MSP.admin', \"Org1MSP.member\"))")
+	if err != nil {
+		t.Fatal(err)
+	}
+	or := envelope.Rule.GetNOutOf()
+	if or.GetN() != 1 || len(or.GetRules()) != 2 || or.GetRules()[0].GetSignedBy() != 0 {
+		t.Fatalf("unexpected rule %v", envelope.Rule)
+	}
+	if and := or.GetRules()[1].GetNOutOf(); and.GetN() != 2 || and.GetRules()[1].GetSignedBy() != 2 {
+		t.Errorf("expected AND to need both principals, got %v", and)
+	}
+	// Repeated MSPs still get an identity each, as the peer CLI encodes them
+	if len(envelope.Identities) != 3 {
+		t.Fatalf("expected three identities, got %d", len(envelope.Identities))
+	}
+	var role msp.MSPRole
+	proto.Unmarshal(envelope.Identities[1].Principal, &role)
+	if role.MspIdentifier != "Org2MSP" || role.Role != msp.MSPRole_ADMIN {
+		t.Errorf("unexpected principal %v", &role)
+	}
+	if outOf, err := signaturePolicyFromString("OutOf(2, 'A.peer', 'B.peer', 'C.peer')"); err != nil || outOf.Rule.GetNOutOf().GetN() != 2 {
+		t.Errorf("expected two out of three, got %v, %v", outOf, err)
+	}
+
+	for _, policy := range []string{"OR('Org1MSP')", "OR('Org1MSP.auditor')", "OutOf(3, 'A.peer', 'B.peer')", "AND('A.peer'", "OR('A.peer') x", "NOT('A.peer')", "OR('A.peer)"} {
+		if _, err := signaturePolicyFromString(policy); err == nil {
+			t.Errorf("expected %s to be refused", policy)
+		}
+	}
+}
+
+func TestPackageChaincode(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"go.mod":             "module example.com/sihcc\n\ngo 1.23.0\n",
+		"main.go":            "package main\n",
+		"vendor/modules.txt": "# example.com/dep v1.0.0\n",
+		".git/config":        "[core]\n",
+		"META-INF/statedb/couchdb/indexes/indexDocType.json": `{"index":{"fields":["docType"]}}`,
+	} {
+		os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0o755)
+		os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644)
+	}
+	pkg, err := packageChaincode(dir, "sihcc_1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, _ := packageChaincode(dir, "sihcc_1.0")
+	if !bytes.Equal(pkg, again) {
+		t.Error("expected the same source to give the same package")
+	}
+	if id := chaincodePackageID("sihcc_1.0", pkg); !strings.HasPrefix(id, "sihcc_1.0:") || len(id) != len("sihcc_1.0:")+64 {
+		t.Errorf("unexpected package ID %s", id)
+	}
+
+	files := untar(t, pkg)
+	if string(files["metadata.json"]) != `{"path":"example.com/sihcc","type":"golang","label":"sihcc_1.0"}` {
+		t.Errorf("unexpected metadata %s", files["metadata.json"])
+	}
+	var names []string
+	for name := range untar(t, files["code.tar.gz"]) {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	want := []string{"META-INF/statedb/couchdb/indexes/indexDocType.json", "src/go.mod", "src/main.go", "src/vendor/modules.txt"}
+	if !slices.Equal(names, want) {
+		t.Errorf("expected %v in the code archive, got %v", want, names)
+	}
+
+	if _, err := packageChaincode(t.TempDir(), "x"); err == nil {
+		t.Error("expected a directory without go.mod to be refused")
+	}
+}
+
+func untar(t *testing.T, data []byte) map[string][]byte {
+	t.Helper()
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[header.Name], _ = io.ReadAll(tr)
+	}
+}
+
+func TestChaincodeDefinitions(t *testing.T) {
+	topology, err := loadNetworkTopology("../test-network/topology.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc := topology.Chaincode
+	if cc.Label != "sihcc_1.0" || cc.Package != "channel-artifacts/sihcc_1.0.tar.gz" || cc.History != "channel-artifacts/mychannel-sihcc-history.json" {
+		t.Errorf("unexpected chaincode defaults %+v", cc)
+	}
+	cc.ChannelConfigPolicy = "/Channel/Application/Writers"
+	if err := topology.validate(); err == nil {
+		t.Error("expected both policies to be refused")
+	}
+
+	collections, _ := proto.Marshal(&peer.CollectionConfigPackage{})
+	d := chaincodeDefinition{Sequence: 2, Version: "1.1", PackageID: "sihcc_1.1:abc", ValidationParameter: []byte("policy"), Collections: collections}
+	committed := &lifecycle.QueryChaincodeDefinitionResult{Sequence: 1, Version: "1.1", EndorsementPlugin: "escc", ValidationPlugin: "vscc", ValidationParameter: []byte("policy")}
+	if !d.matchesCommitted(committed) {
+		t.Error("expected a definition without collections to match the committed one")
+	}
+	if committed.InitRequired = true; d.matchesCommitted(committed) {
+		t.Error("expected a changed init requirement to differ")
+	}
+	approved := &lifecycle.QueryApprovedChaincodeDefinitionResult{
+		Sequence: 2, Version: "1.1", EndorsementPlugin: "escc", ValidationPlugin: "vscc", ValidationParameter: []byte("policy"),
+		Source: &lifecycle.ChaincodeSource{Type: &lifecycle.ChaincodeSource_LocalPackage{LocalPackage: &lifecycle.ChaincodeSource_Local{PackageId: "sihcc_1.1:abc"}}},
+	}
+	if !d.matchesApproved(approved) {
+		t.Error("expected the approval to match")
+	}
+	approved.Source.GetLocalPackage().PackageId = "sihcc_1.1:def"
+	if d.matchesApproved(approved) {
+		t.Error("expected an approval of another package to differ")
+	}
+
+	history := []chaincodeDefinition{{Sequence: 1, Version: "1.0", PackageID: "sihcc_1.0:a"}, {Sequence: 2, Version: "1.1"}, {Sequence: 3, Version: "1.2", PackageID: "sihcc_1.2:c"}}
+	if target, err := rollbackTarget(history, 3); err != nil || target.Version != "1.0" {
+		t.Errorf("expected to skip the record without a package and return to 1.0, got %+v, %v", target, err)
+	}
+	if _, err := rollbackTarget(history, 1); err == nil {
+		t.Error("expected nothing to roll back to from the first sequence")
+	}
+}
+
+func TestEndorsedTransaction(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	sign, err := identity.NewPrivateKeySign(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin := &networkAdmin{creator: []byte("Org1MSP admin"), sign: sign}
+	sp, err := newSignedProposal(admin, "mychannel", lifecycleChaincode, []byte("CommitChaincodeDefinition"), []byte("args"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var channelHeader common.ChannelHeader
+	proto.Unmarshal(sp.header.ChannelHeader, &channelHeader)
+	if channelHeader.ChannelId != "mychannel" || len(channelHeader.TxId) != 64 {
+		t.Errorf("unexpected channel header %v", &channelHeader)
+	}
+
+	responses := []*peer.ProposalResponse{
+		{Payload: []byte("result"), Endorsement: &peer.Endorsement{Endorser: []byte("peer0.org1")}},
+		{Payload: []byte("result"), Endorsement: &peer.Endorsement{Endorser: []byte("peer0.org2")}},
+	}
+	envelope, err := endorsedTransaction(admin, sp, responses)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var payload common.Payload
+	var transaction peer.Transaction
+	var action peer.ChaincodeActionPayload
+	proto.Unmarshal(envelope.Payload, &payload)
+	proto.Unmarshal(payload.Data, &transaction)
+	proto.Unmarshal(transaction.Actions[0].Payload, &action)
+	if len(action.Action.Endorsements) != 2 || !bytes.Equal(action.ChaincodeProposalPayload, sp.proposal.Payload) || !bytes.Equal(payload.Header.ChannelHeader, sp.header.ChannelHeader) {
+		t.Errorf("expected both endorsements on the proposal's transaction, got %v", &action)
+	}
+
+	responses[1].Payload = []byte("other")
+	if _, err := endorsedTransaction(admin, sp, responses); err == nil {
+		t.Error("expected differing results to be refused")
+	}
+}
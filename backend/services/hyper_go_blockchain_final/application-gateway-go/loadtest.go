@@ -0,0 +1,776 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+// Simulated tourist actions
+const (
+	loadVerify   = "verify"
+	loadPing     = "ping"
+	loadIncident = "incident"
+	loadSOS      = "sos"
+
+	// Setup issues each tourist's DID and location consent before the stages start
+	loadSetupDID     = "setup_did"
+	loadSetupConsent = "setup_consent"
+)
+
+var loadActionKinds = []string{loadVerify, loadPing, loadIncident, loadSOS}
+
+const (
+	defaultLoadTimeout = 30 * time.Second
+	defaultLoadSetup   = 20
+	// The tourist count moves towards a stage's target at this interval while it ramps
+	loadRampTick = 100 * time.Millisecond
+	// A simulated tourist wanders at most this far between two pings
+	loadStepMeters = 25.0
+)
+
+// loadScenario is a scripted load test: the tourists to simulate in each stage, what each one does
+// and how often. Durations are Go duration strings.
+type loadScenario struct {
+	Name    string `json:"name"`
+	BaseURL string `json:"baseURL"`
+	// DIDMethod and Issuer shape the DIDs issued to simulated tourists
+	DIDMethod string `json:"didMethod,omitempty"`
+	Issuer    string `json:"issuer,omitempty"`
+	// Seed makes tourist positions, action timing and choices repeatable
+	Seed             uint64       `json:"seed,omitempty"`
+	Timeout          string       `json:"timeout,omitempty"`
+	SetupConcurrency int          `json:"setupConcurrency,omitempty"`
+	GrantConsent     bool         `json:"grantConsent,omitempty"`
+	Region           loadRegion   `json:"region"`
+	Stages           []loadStage  `json:"stages"`
+	Actions          []loadAction `json:"actions"`
+}
+
+// loadRegion is the circle tourists start in
+type loadRegion struct {
+	Latitude     float64 `json:"latitude"`
+	Longitude    float64 `json:"longitude"`
+	RadiusMeters float64 `json:"radiusMeters"`
+}
+
+// loadStage moves the number of active tourists to Tourists over Ramp, then holds it for Duration
+type loadStage struct {
+	Name     string `json:"name"`
+	Tourists int    `json:"tourists"`
+	Ramp     string `json:"ramp,omitempty"`
+	Duration string `json:"duration"`
+}
+
+func (s loadStage) ramp() time.Duration {
+	d, _ := time.ParseDuration(s.Ramp)
+	return d
+}
+
+func (s loadStage) duration() time.Duration {
+	d, _ := time.ParseDuration(s.Duration)
+	return d
+}
+
+// loadAction is something each tourist does on average once per Every, at exponentially
+// distributed intervals so thousands of tourists do not act in lockstep. Batch is the number of
+// pings per upload. MaxP99 and MaxErrorRate fail the run when the action is slower or less
+// reliable than that.
+type loadAction struct {
+	Kind         string   `json:"kind"`
+	Every        string   `json:"every"`
+	Batch        int      `json:"batch,omitempty"`
+	MaxP99       string   `json:"maxP99,omitempty"`
+	MaxErrorRate *float64 `json:"maxErrorRate,omitempty"`
+}
+
+func (a loadAction) every() time.Duration {
+	d, _ := time.ParseDuration(a.Every)
+	return d
+}
+
+func readLoadScenario(filename string) (*loadScenario, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read scenario: %w", err)
+	}
+	var s loadScenario
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&s); err != nil {
+		return nil, fmt.Errorf("invalid scenario %s: %w", filename, err)
+	}
+	if s.DIDMethod == "" {
+		s.DIDMethod = "sih"
+	}
+	if s.Issuer == "" {
+		s.Issuer = "loadtest"
+	}
+	if s.Timeout == "" {
+		s.Timeout = defaultLoadTimeout.String()
+	}
+	if s.SetupConcurrency == 0 {
+		s.SetupConcurrency = defaultLoadSetup
+	}
+	for i := range s.Actions {
+		if s.Actions[i].Kind == loadPing && s.Actions[i].Batch == 0 {
+			s.Actions[i].Batch = 1
+		}
+	}
+	return &s, s.validate()
+}
+
+func (s *loadScenario) validate() error {
+	if u, err := url.Parse(s.BaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("scenario %s: baseURL must be an http or https URL", s.Name)
+	}
+	if !identifierRegex.MatchString(s.Issuer) || !digitalIDRegex.MatchString("did:"+s.DIDMethod+":x") {
+		return fmt.Errorf("scenario %s: invalid issuer or DID method", s.Name)
+	}
+	if timeout, err := time.ParseDuration(s.Timeout); err != nil || timeout <= 0 {
+		return fmt.Errorf("scenario %s: invalid timeout %q", s.Name, s.Timeout)
+	}
+	if s.SetupConcurrency < 1 {
+		return fmt.Errorf("scenario %s: setupConcurrency must be positive", s.Name)
+	}
+	r := s.Region
+	if r.Latitude < -90 || r.Latitude > 90 || r.Longitude < -180 || r.Longitude > 180 || r.RadiusMeters < 0 {
+		return fmt.Errorf("scenario %s: invalid region", s.Name)
+	}
+
+	if len(s.Stages) == 0 {
+		return fmt.Errorf("scenario %s has no stages", s.Name)
+	}
+	for _, stage := range s.Stages {
+		if stage.Tourists < 0 {
+			return fmt.Errorf("stage %s: tourists must not be negative", stage.Name)
+		}
+		if d, err := time.ParseDuration(stage.Duration); err != nil || d < 0 {
+			return fmt.Errorf("stage %s: invalid duration %q", stage.Name, stage.Duration)
+		}
+		if d, err := time.ParseDuration(stage.Ramp); stage.Ramp != "" && (err != nil || d < 0) {
+			return fmt.Errorf("stage %s: invalid ramp %q", stage.Name, stage.Ramp)
+		}
+	}
+	if s.maxTourists() == 0 {
+		return fmt.Errorf("scenario %s simulates no tourists", s.Name)
+	}
+
+	if len(s.Actions) == 0 {
+		return fmt.Errorf("scenario %s has no actions", s.Name)
+	}
+	seen := map[string]bool{}
+	for _, a := range s.Actions {
+		if !slices.Contains(loadActionKinds, a.Kind) {
+			return fmt.Errorf("unknown action %q; expected one of %s", a.Kind, strings.Join(loadActionKinds, ", "))
+		}
+		if seen[a.Kind] {
+			return fmt.Errorf("action %s is listed twice", a.Kind)
+		}
+		seen[a.Kind] = true
+		if d, err := time.ParseDuration(a.Every); err != nil || d <= 0 {
+			return fmt.Errorf("action %s: invalid interval %q", a.Kind, a.Every)
+		}
+		if a.Batch < 0 || a.Batch > maxLocationBatch || (a.Batch > 0 && a.Kind != loadPing) {
+			return fmt.Errorf("action %s: batch must be between 1 and %d pings", a.Kind, maxLocationBatch)
+		}
+		if d, err := time.ParseDuration(a.MaxP99); a.MaxP99 != "" && (err != nil || d <= 0) {
+			return fmt.Errorf("action %s: invalid maxP99 %q", a.Kind, a.MaxP99)
+		}
+		if a.MaxErrorRate != nil && (*a.MaxErrorRate < 0 || *a.MaxErrorRate > 1) {
+			return fmt.Errorf("action %s: maxErrorRate must be between 0 and 1", a.Kind)
+		}
+	}
+	return nil
+}
+
+func (s *loadScenario) maxTourists() int {
+	most := 0
+	for _, stage := range s.Stages {
+		most = max(most, stage.Tourists)
+	}
+	return most
+}
+
+// RunLoadTest is the sih-loadtest entry point
+func RunLoadTest() {
+	flags := flag.NewFlagSet("sih-loadtest", flag.ExitOnError)
+	scenarioFile := flags.String("scenario", getEnv("LOADTEST_SCENARIO", "scenarios/tourist-day.json"), "scenario file describing the stages and tourist actions")
+	baseURL := flags.String("url", getEnv("LOADTEST_URL", ""), "gateway URL, overriding the scenario's baseURL")
+	apiKey := flags.String("api-key", getEnv("LOADTEST_API_KEY", ""), "API key sent with every request")
+	reportFile := flags.String("report", "", "also write the report as JSON to this file")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: sih-loadtest [flags]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(os.Args[1:])
+	if flags.NArg() != 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	scenario, err := readLoadScenario(*scenarioFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *baseURL != "" {
+		scenario.BaseURL = *baseURL
+		if err := scenario.validate(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if !scenario.GrantConsent && slices.ContainsFunc(scenario.Actions, func(a loadAction) bool { return a.Kind == loadPing }) {
+		log.Printf("⚠️ Scenario %s pings without granting location consent; pings fail if the gateway enforces consent", scenario.Name)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	r := newLoadRunner(scenario, *apiKey)
+	report, err := r.run(ctx)
+	if err != nil {
+		stop()
+		log.Fatalf("❌ Load test failed: %v", err)
+	}
+	report.write(os.Stdout)
+	if *reportFile != "" {
+		data, _ := json.MarshalIndent(report, "", "  ")
+		if err := os.WriteFile(*reportFile, append(data, '\n'), 0o644); err != nil {
+			stop()
+			log.Fatalf("❌ Failed to write report: %v", err)
+		}
+	}
+	if len(report.Failures) > 0 {
+		stop()
+		os.Exit(1)
+	}
+}
+
+// loadRunner drives one run of a scenario against a gateway
+type loadRunner struct {
+	scenario *loadScenario
+	client   *http.Client
+	baseURL  string
+	apiKey   string
+	// runID keeps the DIDs, incidents and alerts of separate runs apart on the ledger
+	runID    string
+	sequence atomic.Uint64
+}
+
+func newLoadRunner(scenario *loadScenario, apiKey string) *loadRunner {
+	timeout, _ := time.ParseDuration(scenario.Timeout)
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// Every tourist shares the client, so keep enough idle connections to avoid redialling
+	transport.MaxIdleConns = scenario.maxTourists() + scenario.SetupConcurrency
+	transport.MaxIdleConnsPerHost = transport.MaxIdleConns
+	return &loadRunner{
+		scenario: scenario,
+		client:   &http.Client{Timeout: timeout, Transport: transport},
+		baseURL:  strings.TrimSuffix(scenario.BaseURL, "/") + "/api/v1",
+		apiKey:   apiKey,
+		runID:    strconv.FormatInt(time.Now().UnixMilli(), 36),
+	}
+}
+
+func (r *loadRunner) run(ctx context.Context) (*loadReport, error) {
+	report := &loadReport{Scenario: r.scenario.Name, RunID: r.runID, StartedAt: time.Now().UTC().Format(time.RFC3339)}
+
+	setup := newLoadStats()
+	started := time.Now()
+	log.Printf("🧳 Issuing %d tourist DIDs", r.scenario.maxTourists())
+	ids := r.setup(ctx, setup)
+	report.Setup = setup.report(time.Since(started))
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("no tourist DIDs could be issued; check the URL and API key")
+	}
+	if len(ids) < r.scenario.maxTourists() {
+		log.Printf("⚠️ Only %d of %d tourist DIDs were issued; stages are capped at %d tourists", len(ids), r.scenario.maxTourists(), len(ids))
+	}
+
+	stats := newLoadStats()
+	started = time.Now()
+	report.Tourists = r.stages(ctx, stats, ids)
+	report.Run = stats.report(time.Since(started))
+	report.Failures = r.scenario.checkThresholds(report.Run)
+	return report, nil
+}
+
+// setup issues a DID for each tourist and grants its location consent, returning the DIDs that
+// are ready in tourist order
+func (r *loadRunner) setup(ctx context.Context, stats *loadStats) []string {
+	count := r.scenario.maxTourists()
+	ready := make([]bool, count)
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range min(r.scenario.SetupConcurrency, count) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				ready[i] = r.issue(ctx, stats, r.touristID(i))
+			}
+		}()
+	}
+	for i := 0; i < count && ctx.Err() == nil; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	var ids []string
+	for i, ok := range ready {
+		if ok {
+			ids = append(ids, r.touristID(i))
+		}
+	}
+	return ids
+}
+
+func (r *loadRunner) touristID(i int) string {
+	return fmt.Sprintf("did:%s:load-%s-%d", r.scenario.DIDMethod, r.runID, i)
+}
+
+func (r *loadRunner) issue(ctx context.Context, stats *loadStats, digitalID string) bool {
+	expiresAt := time.Now().Add(24 * time.Hour).UTC().Format(time.RFC3339)
+	consentHash := sha256.Sum256([]byte(digitalID))
+	status := r.call(ctx, stats, loadSetupDID, http.MethodPost, "/did/", CreateDIDRequest{
+		DigitalID:   digitalID,
+		ConsentHash: hex.EncodeToString(consentHash[:]),
+		ExpiresAt:   expiresAt,
+		Issuer:      r.scenario.Issuer,
+	})
+	if status/100 != 2 {
+		return false
+	}
+	if !r.scenario.GrantConsent {
+		return true
+	}
+	status = r.call(ctx, stats, loadSetupConsent, http.MethodPost, "/consents/"+url.PathEscape(digitalID)+"/grant", GrantConsentRequest{
+		Purpose:       purposeLocationTracking,
+		PolicyVersion: "loadtest",
+		ExpiresAt:     expiresAt,
+		Actor:         r.scenario.Issuer,
+	})
+	return status/100 == 2
+}
+
+// stages runs each stage in turn, starting and stopping tourists as the stage ramps, and returns
+// the most tourists active at once
+func (r *loadRunner) stages(ctx context.Context, stats *loadStats, ids []string) int {
+	var wg sync.WaitGroup
+	var cancels []context.CancelFunc
+	peak := 0
+	scale := func(n int) {
+		n = min(n, len(ids))
+		for len(cancels) < n {
+			i := len(cancels)
+			touristCtx, cancel := context.WithCancel(ctx)
+			cancels = append(cancels, cancel)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				r.tourist(touristCtx, stats, i, ids[i])
+			}()
+		}
+		for len(cancels) > n {
+			cancels[len(cancels)-1]()
+			cancels = cancels[:len(cancels)-1]
+		}
+		peak = max(peak, n)
+	}
+	defer func() {
+		scale(0)
+		wg.Wait()
+	}()
+
+	for _, stage := range r.scenario.Stages {
+		from, ramp := len(cancels), stage.ramp()
+		log.Printf("🚦 Stage %s: %d → %d tourists over %s, then %s", stage.Name, from, min(stage.Tourists, len(ids)), ramp, stage.duration())
+		if ramp > 0 {
+			ticker := time.NewTicker(loadRampTick)
+			start := time.Now()
+			for elapsed := time.Duration(0); elapsed < ramp; elapsed = time.Since(start) {
+				scale(from + int(float64(stage.Tourists-from)*float64(elapsed)/float64(ramp)))
+				select {
+				case <-ctx.Done():
+					ticker.Stop()
+					return peak
+				case <-ticker.C:
+				}
+			}
+			ticker.Stop()
+		}
+		scale(stage.Tourists)
+		select {
+		case <-ctx.Done():
+			return peak
+		case <-time.After(stage.duration()):
+		}
+	}
+	return peak
+}
+
+// simTourist is one simulated tourist wandering around the scenario's region
+type simTourist struct {
+	digitalID string
+	rng       *rand.Rand
+	lat, lng  float64
+}
+
+// tourist performs the scenario's actions for one tourist until ctx is cancelled
+func (r *loadRunner) tourist(ctx context.Context, stats *loadStats, index int, digitalID string) {
+	t := &simTourist{digitalID: digitalID, rng: rand.New(rand.NewPCG(r.scenario.Seed, uint64(index)))}
+	t.lat, t.lng = t.offset(r.scenario.Region.Latitude, r.scenario.Region.Longitude, r.scenario.Region.RadiusMeters)
+
+	actions := r.scenario.Actions
+	next := make([]time.Time, len(actions))
+	for i, a := range actions {
+		next[i] = time.Now().Add(t.interval(a.every()))
+	}
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		i := 0
+		for j := range next {
+			if next[j].Before(next[i]) {
+				i = j
+			}
+		}
+		timer.Reset(time.Until(next[i]))
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		r.perform(ctx, stats, t, actions[i])
+		next[i] = time.Now().Add(t.interval(actions[i].every()))
+	}
+}
+
+// interval draws the wait before an action from an exponential distribution with the given mean
+func (t *simTourist) interval(mean time.Duration) time.Duration {
+	return time.Duration(t.rng.ExpFloat64() * float64(mean))
+}
+
+func (t *simTourist) offset(lat, lng, radius float64) (float64, float64) {
+	return offsetPoint(t.rng, lat, lng, radius)
+}
+
+// offsetPoint returns a point uniformly distributed within radius meters of lat, lng
+func offsetPoint(rng *rand.Rand, lat, lng, radius float64) (float64, float64) {
+	distance := radius * math.Sqrt(rng.Float64())
+	bearing := 2 * math.Pi * rng.Float64()
+	dLat := distance * math.Cos(bearing) / 111320
+	dLng := distance * math.Sin(bearing) / (111320 * math.Max(math.Cos(lat*math.Pi/180), 0.01))
+	return math.Max(-90, math.Min(90, lat+dLat)), math.Mod(lng+dLng+540, 360) - 180
+}
+
+func (r *loadRunner) perform(ctx context.Context, stats *loadStats, t *simTourist, a loadAction) {
+	id := url.PathEscape(t.digitalID)
+	switch a.Kind {
+	case loadVerify:
+		r.call(ctx, stats, a.Kind, http.MethodGet, "/did/"+id+"/verify", nil)
+
+	case loadPing:
+		// The batch covers the interval since the last upload, the way the app buffers pings
+		spacing := a.every() / time.Duration(a.Batch)
+		now := time.Now()
+		pings := make([]LocationPing, a.Batch)
+		for i := range pings {
+			t.lat, t.lng = t.offset(t.lat, t.lng, loadStepMeters)
+			lat, lng, battery := t.lat, t.lng, 20+t.rng.IntN(80)
+			pings[i] = LocationPing{
+				Latitude:   &lat,
+				Longitude:  &lng,
+				Accuracy:   5 + 20*t.rng.Float64(),
+				Battery:    &battery,
+				RecordedAt: now.Add(-time.Duration(a.Batch-1-i) * spacing).UTC().Format(time.RFC3339),
+			}
+		}
+		r.call(ctx, stats, a.Kind, http.MethodPost, "/location/pings", LocationPingsRequest{DigitalID: t.digitalID, Pings: pings})
+
+	case loadIncident:
+		lat, lng := t.lat, t.lng
+		var summary [8]byte
+		binary.BigEndian.PutUint64(summary[:], t.rng.Uint64())
+		hash := sha256.Sum256(summary[:])
+		r.call(ctx, stats, a.Kind, http.MethodPost, "/incident/", CreateIncidentRequest{
+			IncidentID:          r.nextID("INC"),
+			IncidentSummaryHash: hex.EncodeToString(hash[:]),
+			Reporter:            r.scenario.Issuer,
+			Severity:            incidentSeverities[t.rng.IntN(len(incidentSeverities))],
+			Category:            incidentCategories[t.rng.IntN(len(incidentCategories))],
+			DigitalID:           t.digitalID,
+			Latitude:            &lat,
+			Longitude:           &lng,
+		})
+
+	case loadSOS:
+		lat, lng := t.lat, t.lng
+		r.call(ctx, stats, a.Kind, http.MethodPost, "/sos", SOSRequest{
+			AlertID:   r.nextID("SOS"),
+			DigitalID: t.digitalID,
+			Latitude:  &lat,
+			Longitude: &lng,
+			Accuracy:  10,
+			Message:   "Load test alert",
+			Source:    "app",
+		})
+	}
+}
+
+func (r *loadRunner) nextID(prefix string) string {
+	return fmt.Sprintf("LOAD-%s-%s-%d", prefix, r.runID, r.sequence.Add(1))
+}
+
+// call sends one request and records its latency and outcome, returning the HTTP status or 0 when
+// no response arrived. Requests cut short by the end of a stage are not recorded.
+func (r *loadRunner) call(ctx context.Context, stats *loadStats, op, method, path string, body interface{}) int {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return 0
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, r.baseURL+path, reader)
+	if err != nil {
+		return 0
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if r.apiKey != "" {
+		req.Header.Set(apiKeyHeader, r.apiKey)
+	}
+
+	start := time.Now()
+	resp, err := r.client.Do(req)
+	if err != nil {
+		if ctx.Err() == nil {
+			stats.record(op, time.Since(start), 0, nil)
+		}
+		return 0
+	}
+	defer resp.Body.Close()
+	var envelope APIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil && ctx.Err() != nil {
+		return 0
+	}
+	stats.record(op, time.Since(start), resp.StatusCode, envelope.BlockNumber)
+	return resp.StatusCode
+}
+
+// loadStats collects the latency and outcome of every request in a phase
+type loadStats struct {
+	mu     sync.Mutex
+	ops    map[string]*loadOpStats
+	blocks map[uint64]bool
+	// commits counts writes the gateway reported committed in a block
+	commits int
+}
+
+type loadOpStats struct {
+	latencies []time.Duration
+	statuses  map[int]int
+	errors    int
+}
+
+func newLoadStats() *loadStats {
+	return &loadStats{ops: map[string]*loadOpStats{}, blocks: map[uint64]bool{}}
+}
+
+func (s *loadStats) record(op string, latency time.Duration, status int, block *uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o := s.ops[op]
+	if o == nil {
+		o = &loadOpStats{statuses: map[int]int{}}
+		s.ops[op] = o
+	}
+	o.latencies = append(o.latencies, latency)
+	o.statuses[status]++
+	if status/100 != 2 {
+		o.errors++
+	} else if block != nil {
+		s.commits++
+		s.blocks[*block] = true
+	}
+}
+
+// loadReport is the outcome of a run, written as a table and optionally as JSON
+type loadReport struct {
+	Scenario  string          `json:"scenario"`
+	RunID     string          `json:"run_id"`
+	StartedAt string          `json:"started_at"`
+	Tourists  int             `json:"peak_tourists"`
+	Setup     loadPhaseReport `json:"setup"`
+	Run       loadPhaseReport `json:"run"`
+	Failures  []string        `json:"threshold_failures,omitempty"`
+}
+
+type loadPhaseReport struct {
+	DurationSeconds  float64        `json:"duration_seconds"`
+	Operations       []loadOpReport `json:"operations"`
+	Commits          int            `json:"commits"`
+	CommitsPerSecond float64        `json:"commits_per_second"`
+	Blocks           int            `json:"blocks"`
+}
+
+type loadOpReport struct {
+	Operation         string         `json:"operation"`
+	Requests          int            `json:"requests"`
+	Errors            int            `json:"errors"`
+	ErrorRate         float64        `json:"error_rate"`
+	RequestsPerSecond float64        `json:"requests_per_second"`
+	P50Ms             float64        `json:"p50_ms"`
+	P90Ms             float64        `json:"p90_ms"`
+	P95Ms             float64        `json:"p95_ms"`
+	P99Ms             float64        `json:"p99_ms"`
+	MaxMs             float64        `json:"max_ms"`
+	Statuses          map[string]int `json:"statuses"`
+}
+
+func (s *loadStats) report(elapsed time.Duration) loadPhaseReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	seconds := math.Max(elapsed.Seconds(), 1e-9)
+	phase := loadPhaseReport{
+		DurationSeconds:  math.Round(elapsed.Seconds()*10) / 10,
+		Commits:          s.commits,
+		CommitsPerSecond: math.Round(float64(s.commits)/seconds*100) / 100,
+		Blocks:           len(s.blocks),
+	}
+	names := make([]string, 0, len(s.ops))
+	for name := range s.ops {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		o := s.ops[name]
+		latencies := slices.Clone(o.latencies)
+		slices.Sort(latencies)
+		statuses := map[string]int{}
+		for status, n := range o.statuses {
+			key := strconv.Itoa(status)
+			if status == 0 {
+				key = "no_response"
+			}
+			statuses[key] = n
+		}
+		phase.Operations = append(phase.Operations, loadOpReport{
+			Operation:         name,
+			Requests:          len(latencies),
+			Errors:            o.errors,
+			ErrorRate:         math.Round(float64(o.errors)/float64(len(latencies))*1e4) / 1e4,
+			RequestsPerSecond: math.Round(float64(len(latencies))/seconds*100) / 100,
+			P50Ms:             milliseconds(percentile(latencies, 50)),
+			P90Ms:             milliseconds(percentile(latencies, 90)),
+			P95Ms:             milliseconds(percentile(latencies, 95)),
+			P99Ms:             milliseconds(percentile(latencies, 99)),
+			MaxMs:             milliseconds(latencies[len(latencies)-1]),
+			Statuses:          statuses,
+		})
+	}
+	return phase
+}
+
+// percentile is the nearest-rank percentile of sorted latencies
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	return sorted[min(max(rank, 1), len(sorted))-1]
+}
+
+func milliseconds(d time.Duration) float64 {
+	return math.Round(float64(d)/float64(time.Millisecond)*10) / 10
+}
+
+// checkThresholds lists every action whose p99 latency or error rate exceeds the scenario's limits
+func (s *loadScenario) checkThresholds(phase loadPhaseReport) []string {
+	var failures []string
+	for _, a := range s.Actions {
+		i := slices.IndexFunc(phase.Operations, func(o loadOpReport) bool { return o.Operation == a.Kind })
+		if i < 0 {
+			continue
+		}
+		o := phase.Operations[i]
+		if limit, _ := time.ParseDuration(a.MaxP99); limit > 0 && o.P99Ms > milliseconds(limit) {
+			failures = append(failures, fmt.Sprintf("%s p99 %.1fms exceeds %s", a.Kind, o.P99Ms, a.MaxP99))
+		}
+		if a.MaxErrorRate != nil && o.ErrorRate > *a.MaxErrorRate {
+			failures = append(failures, fmt.Sprintf("%s error rate %.2f%% exceeds %.2f%%", a.Kind, o.ErrorRate*100, *a.MaxErrorRate*100))
+		}
+	}
+	return failures
+}
+
+func (report *loadReport) write(w io.Writer) {
+	fmt.Fprintf(w, "Scenario %s (run %s), peak %d tourists\n", report.Scenario, report.RunID, report.Tourists)
+	for _, phase := range []struct {
+		name string
+		loadPhaseReport
+	}{{"Setup", report.Setup}, {"Run", report.Run}} {
+		fmt.Fprintf(w, "\n%s: %.1fs, %d commits in %d blocks (%.2f commits/s)\n", phase.name, phase.DurationSeconds, phase.Commits, phase.Blocks, phase.CommitsPerSecond)
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintln(tw, "operation\trequests\terrors\treq/s\tp50 ms\tp90 ms\tp95 ms\tp99 ms\tmax ms\t")
+		for _, o := range phase.Operations {
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%.2f\t%.1f\t%.1f\t%.1f\t%.1f\t%.1f\t\n", o.Operation, o.Requests, o.Errors, o.RequestsPerSecond, o.P50Ms, o.P90Ms, o.P95Ms, o.P99Ms, o.MaxMs)
+		}
+		tw.Flush()
+		for _, o := range phase.Operations {
+			if o.Errors > 0 {
+				fmt.Fprintf(w, "  %s responses: %s\n", o.Operation, formatStatuses(o.Statuses))
+			}
+		}
+	}
+	for _, failure := range report.Failures {
+		fmt.Fprintf(w, "❌ %s\n", failure)
+	}
+}
+
+// formatStatuses lists response counts by status, such as "201×950 429×50"
+func formatStatuses(statuses map[string]int) string {
+	keys := make([]string, 0, len(statuses))
+	for key := range statuses {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	parts := make([]string, len(keys))
+	for i, key := range keys {
+		parts[i] = fmt.Sprintf("%s×%d", key, statuses[key])
+	}
+	return strings.Join(parts, " ")
+}
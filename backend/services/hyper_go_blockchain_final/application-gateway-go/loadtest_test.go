@@ -0,0 +1,198 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReadLoadScenario(t *testing.T) {
+	scenario, err := readLoadScenario("scenarios/tourist-day.json")
+	if err != nil {
+		t.Fatalf("expected the sample scenario to load, got %v", err)
+	}
+	if scenario.maxTourists() != 3000 || scenario.DIDMethod != "sih" || scenario.Timeout != "30s" || scenario.SetupConcurrency != defaultLoadSetup {
+		t.Errorf("unexpected scenario defaults %+v", scenario)
+	}
+
+	for name, edit := range map[string]func(*loadScenario){
+		"url":      func(s *loadScenario) { s.BaseURL = "localhost:8080" },
+		"tourists": func(s *loadScenario) { s.Stages = []loadStage{{Name: "idle", Duration: "1m"}} },
+		"ramp":     func(s *loadScenario) { s.Stages[0].Ramp = "soon" },
+		"kind":     func(s *loadScenario) { s.Actions[0].Kind = "dance" },
+		"twice":    func(s *loadScenario) { s.Actions[1].Kind = s.Actions[0].Kind },
+		"every":    func(s *loadScenario) { s.Actions[0].Every = "0s" },
+		"batch":    func(s *loadScenario) { s.Actions[0].Batch = maxLocationBatch + 1 },
+		"sosBatch": func(s *loadScenario) { s.Actions[3].Batch = 2 },
+		"rate":     func(s *loadScenario) { rate := 1.5; s.Actions[0].MaxErrorRate = &rate },
+	} {
+		broken, _ := readLoadScenario("scenarios/tourist-day.json")
+		edit(broken)
+		if err := broken.validate(); err == nil {
+			t.Errorf("expected the %s change to be refused", name)
+		}
+	}
+}
+
+func TestLoadStatsReport(t *testing.T) {
+	sorted := make([]time.Duration, 100)
+	for i := range sorted {
+		sorted[i] = time.Duration(i+1) * time.Millisecond
+	}
+	if p50, p99 := percentile(sorted, 50), percentile(sorted, 99); p50 != 50*time.Millisecond || p99 != 99*time.Millisecond {
+		t.Errorf("expected nearest-rank percentiles, got %s and %s", p50, p99)
+	}
+	if p := percentile(sorted[:1], 99); p != time.Millisecond {
+		t.Errorf("expected a single latency to be every percentile, got %s", p)
+	}
+
+	stats := newLoadStats()
+	block := uint64(7)
+	for i := range 9 {
+		stats.record(loadSOS, time.Duration(i+1)*time.Millisecond, http.StatusCreated, &block)
+	}
+	stats.record(loadSOS, time.Second, http.StatusTooManyRequests, nil)
+	stats.record(loadVerify, time.Millisecond, 0, nil)
+	phase := stats.report(2 * time.Second)
+	if phase.Commits != 9 || phase.Blocks != 1 || phase.CommitsPerSecond != 4.5 {
+		t.Errorf("expected nine commits in one block at 4.5/s, got %+v", phase)
+	}
+	sos := phase.Operations[0]
+	if sos.Operation != loadSOS || sos.Requests != 10 || sos.Errors != 1 || sos.ErrorRate != 0.1 || sos.P50Ms != 5 || sos.MaxMs != 1000 {
+		t.Errorf("unexpected SOS report %+v", sos)
+	}
+	if verify := phase.Operations[1]; verify.Statuses["no_response"] != 1 {
+		t.Errorf("expected a missing response counted, got %v", verify.Statuses)
+	}
+
+	limit := 0.05
+	scenario := &loadScenario{Actions: []loadAction{{Kind: loadSOS, MaxP99: "100ms", MaxErrorRate: &limit}, {Kind: loadPing, MaxP99: "1ms"}}}
+	if failures := scenario.checkThresholds(phase); len(failures) != 2 || !strings.HasPrefix(failures[0], "sos p99 1000.0ms") || !strings.HasPrefix(failures[1], "sos error rate 10.00%") {
+		t.Errorf("expected the SOS latency and error rate to fail, got %v", failures)
+	}
+
+	var out bytes.Buffer
+	(&loadReport{Scenario: "test", Run: phase, Failures: []string{"sos p99"}}).write(&out)
+	for _, want := range []string{"9 commits in 1 blocks (4.50 commits/s)", "sos responses: 201×9 429×1", "❌ sos p99"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("expected %q in the report:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestLoadRunner(t *testing.T) {
+	var mu sync.Mutex
+	requests := map[string]int{}
+	var keys []string
+	var block uint64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		path := r.URL.Path
+		switch {
+		case strings.HasSuffix(path, "/grant"):
+			path = "grant"
+		case strings.HasSuffix(path, "/verify"):
+			path = "verify"
+		}
+		requests[path]++
+		keys = append(keys, r.Header.Get(apiKeyHeader))
+
+		w.Header().Set("Content-Type", "application/json")
+		var body interface {
+			Validate() ValidationErrors
+		}
+		switch path {
+		case "/api/v1/did/":
+			body = &CreateDIDRequest{}
+		case "grant":
+			body = &GrantConsentRequest{}
+		case "/api/v1/location/pings":
+			body = &LocationPingsRequest{}
+		case "/api/v1/incident/":
+			body = &CreateIncidentRequest{}
+		case "/api/v1/sos":
+			body = &SOSRequest{}
+		}
+		if body != nil {
+			json.NewDecoder(r.Body).Decode(body)
+			if errs := body.Validate(); len(errs) > 0 {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(APIResponse{Error: &APIError{Code: errCodeValidation, Message: errs.Error()}})
+				return
+			}
+		}
+		if path == "/api/v1/sos" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"success":false}`))
+			return
+		}
+		if r.Method == http.MethodGet {
+			json.NewEncoder(w).Encode(APIResponse{Success: true})
+			return
+		}
+		block++
+		json.NewEncoder(w).Encode(APIResponse{Success: true, TxID: "tx", BlockNumber: &block})
+	}))
+	defer server.Close()
+
+	noErrors := 0.0
+	scenario := &loadScenario{
+		Name: "test", BaseURL: server.URL, DIDMethod: "sih", Issuer: "loadtest", Timeout: "5s", SetupConcurrency: 2, GrantConsent: true,
+		Region: loadRegion{Latitude: 25.5788, Longitude: 91.8933, RadiusMeters: 500},
+		Stages: []loadStage{{Name: "up", Tourists: 4, Ramp: "200ms", Duration: "200ms"}, {Name: "down", Tourists: 2, Duration: "100ms"}},
+		Actions: []loadAction{
+			{Kind: loadPing, Every: "20ms", Batch: 3, MaxErrorRate: &noErrors},
+			{Kind: loadVerify, Every: "20ms"},
+			{Kind: loadIncident, Every: "50ms", MaxErrorRate: &noErrors},
+			{Kind: loadSOS, Every: "50ms", MaxErrorRate: &noErrors},
+		},
+	}
+	if err := scenario.validate(); err != nil {
+		t.Fatal(err)
+	}
+	report, err := newLoadRunner(scenario, "key-1").run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if requests["/api/v1/did/"] != 4 || requests["grant"] != 4 || report.Setup.Commits != 8 {
+		t.Errorf("expected each tourist issued a DID and consent once, got %v", requests)
+	}
+	if report.Tourists != 4 {
+		t.Errorf("expected four tourists at the peak, got %d", report.Tourists)
+	}
+	for _, path := range []string{"/api/v1/location/pings", "verify", "/api/v1/incident/", "/api/v1/sos"} {
+		if requests[path] == 0 {
+			t.Errorf("expected %s to be exercised, got %v", path, requests)
+		}
+	}
+	for _, key := range keys {
+		if key != "key-1" {
+			t.Fatalf("expected every request to carry the API key, got %q", key)
+		}
+	}
+	ops := map[string]loadOpReport{}
+	for _, o := range report.Run.Operations {
+		ops[o.Operation] = o
+	}
+	if ops[loadPing].Errors != 0 || ops[loadIncident].Errors != 0 {
+		t.Errorf("expected the generated pings and incidents to pass validation, got %+v", ops)
+	}
+	// Requests in flight when a tourist stops reach the server but are not recorded
+	served := requests["/api/v1/location/pings"] + requests["/api/v1/incident/"]
+	if report.Run.Commits == 0 || report.Run.Commits > served {
+		t.Errorf("expected up to %d commits, got %d", served, report.Run.Commits)
+	}
+	if len(report.Failures) != 1 || !strings.HasPrefix(report.Failures[0], "sos error rate 100.00%") {
+		t.Errorf("expected only the failing SOS endpoint to breach its threshold, got %v", report.Failures)
+	}
+}
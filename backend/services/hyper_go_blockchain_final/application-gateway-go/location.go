@@ -0,0 +1,772 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	locationLivePrefix       = "sih:location:live:"
+	locationPendingPrefix    = "sih:location:pending:"
+	locationPendingSetKey    = "sih:location:pending"
+	locationTrailPrefix      = "sih:location:trail:"
+	locationGeoKey           = "sih:location:geo"
+	maxLocationBatch         = 500
+	maxLocationAnchorsPerTx  = 500
+	maxLocationPingAge       = 72 * time.Hour
+	maxLocationPingClockSkew = 5 * time.Minute
+	maxGeoIndexLatitude      = 85.05112878
+)
+
+// LocationPing is one position reported by the tourist app
+type LocationPing struct {
+	Latitude   *float64 `json:"latitude"`
+	Longitude  *float64 `json:"longitude"`
+	Accuracy   float64  `json:"accuracy"`
+	Speed      *float64 `json:"speed,omitempty"`
+	Heading    *float64 `json:"heading,omitempty"`
+	Battery    *int     `json:"battery,omitempty"`
+	RecordedAt string   `json:"recordedAt"`
+	// WiFi and Cells let location fusion place a ping without GPS, or refine a poor fix
+	WiFi  []WiFiObservation `json:"wifi,omitempty"`
+	Cells []CellObservation `json:"cells,omitempty"`
+	// Source is set by location fusion to the sources that placed the ping, such as gps+wifi
+	Source string `json:"source,omitempty"`
+}
+
+// LocationPingsRequest is a batch of pings the app queued since its last upload
+type LocationPingsRequest struct {
+	DigitalID string         `json:"digitalID" binding:"required"`
+	Pings     []LocationPing `json:"pings" binding:"required"`
+}
+
+func (r LocationPingsRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.digitalID("digitalID", r.DigitalID)
+	if len(r.Pings) == 0 || len(r.Pings) > maxLocationBatch {
+		v.add("pings", "must contain between 1 and %d pings", maxLocationBatch)
+	}
+	now := time.Now()
+	for i, ping := range r.Pings {
+		field := func(name string) string { return fmt.Sprintf("pings[%d].%s", i, name) }
+		// With location fusion, Wi-Fi or cell observations can stand in for the coordinates
+		fused := fusion != nil && (len(ping.WiFi) > 0 || len(ping.Cells) > 0) && ping.Latitude == nil && ping.Longitude == nil
+		if ping.Latitude == nil && !fused {
+			v.add(field("latitude"), "is required")
+		} else if ping.Latitude != nil && (*ping.Latitude < -90 || *ping.Latitude > 90) {
+			v.add(field("latitude"), "must be between -90 and 90")
+		}
+		if ping.Longitude == nil && !fused {
+			v.add(field("longitude"), "is required")
+		} else if ping.Longitude != nil && (*ping.Longitude < -180 || *ping.Longitude > 180) {
+			v.add(field("longitude"), "must be between -180 and 180")
+		}
+		validateRadioObservations(&v, field, ping.WiFi, ping.Cells)
+		if ping.Accuracy < 0 {
+			v.add(field("accuracy"), "must not be negative")
+		}
+		if ping.Speed != nil && *ping.Speed < 0 {
+			v.add(field("speed"), "must not be negative")
+		}
+		if ping.Heading != nil && (*ping.Heading < 0 || *ping.Heading >= 360) {
+			v.add(field("heading"), "must be at least 0 and below 360")
+		}
+		if ping.Battery != nil && (*ping.Battery < 0 || *ping.Battery > 100) {
+			v.add(field("battery"), "must be between 0 and 100")
+		}
+		if t, ok := v.rfc3339(field("recordedAt"), ping.RecordedAt); ok {
+			switch {
+			case t.After(now.Add(maxLocationPingClockSkew)):
+				v.add(field("recordedAt"), "must not be in the future")
+			case t.Before(now.Add(-maxLocationPingAge)):
+				v.add(field("recordedAt"), "must be within the last %s", maxLocationPingAge)
+			}
+		}
+	}
+	return v.errors
+}
+
+// LiveLocation is a tourist's most recently recorded position
+type LiveLocation struct {
+	DigitalID  string   `json:"digital_id"`
+	Latitude   float64  `json:"latitude"`
+	Longitude  float64  `json:"longitude"`
+	Accuracy   float64  `json:"accuracy"`
+	Speed      *float64 `json:"speed,omitempty"`
+	Heading    *float64 `json:"heading,omitempty"`
+	Battery    *int     `json:"battery,omitempty"`
+	RecordedAt string   `json:"recorded_at"`
+	ReceivedAt string   `json:"received_at"`
+	// Source names what placed the position when location fusion did, such as wifi or gps+cell
+	Source string `json:"source,omitempty"`
+}
+
+// LocationPingsResult acknowledges a batch. Geofence is evaluated at the live position; Events are
+// the zone changes the batch's new pings caused, in recording order. Unresolved counts the pings
+// without GPS that location fusion could not place, which were dropped.
+type LocationPingsResult struct {
+	DigitalID  string              `json:"digital_id"`
+	Accepted   int                 `json:"accepted"`
+	Unresolved int                 `json:"unresolved,omitempty"`
+	Live       LiveLocation        `json:"live"`
+	Geofence   *GeofenceEvaluation `json:"geofence,omitempty"`
+	Events     []GeofenceEvent     `json:"events,omitempty"`
+	Alerts     []RuleAlert         `json:"alerts,omitempty"`
+	Anomalies  []AnomalyEvent      `json:"anomalies,omitempty"`
+	Safety     *SafetyScore        `json:"safety,omitempty"`
+}
+
+// LocationAnchor is a ledger commitment to one tourist's pings over an anchoring window
+type LocationAnchor struct {
+	AnchorID    string `json:"anchor_id"`
+	DigitalID   string `json:"digital_id"`
+	Digest      string `json:"digest"`
+	PingCount   int    `json:"ping_count"`
+	FirstPingAt string `json:"first_ping_at"`
+	LastPingAt  string `json:"last_ping_at"`
+	AnchoredAt  string `json:"anchored_at,omitempty"`
+	TxID        string `json:"tx_id,omitempty"`
+}
+
+// TrailPoint is one of a tourist's recent pings, as kept for missing person searches
+type TrailPoint struct {
+	Latitude   float64 `json:"latitude"`
+	Longitude  float64 `json:"longitude"`
+	Accuracy   float64 `json:"accuracy"`
+	RecordedAt string  `json:"recorded_at"`
+}
+
+// locationStore keeps live positions and the digest lines of pings awaiting anchoring. It uses Redis
+// when the document cache is configured, so every gateway instance sees the same positions.
+type locationStore interface {
+	// update stores the position unless a later one is already stored
+	update(ctx context.Context, live LiveLocation) (LiveLocation, error)
+	latest(ctx context.Context, digitalID string) (*LiveLocation, error)
+	appendPending(ctx context.Context, digitalID string, lines []string) error
+	// drainPending removes and returns every tourist's pending lines
+	drainPending(ctx context.Context) (map[string][]string, error)
+	// appendTrail adds digest lines to a tourist's trail and keeps the latest keep of them
+	appendTrail(ctx context.Context, digitalID string, lines []string, keep int) error
+	// trail returns a tourist's trail lines, newest first
+	trail(ctx context.Context, digitalID string) ([]string, error)
+	// remove deletes a tourist's live position, pending lines and trail, returning how many there were
+	remove(ctx context.Context, digitalID string) (int, error)
+	// within returns the live positions inside box recorded at or after since
+	within(ctx context.Context, box bounds, since time.Time) ([]LiveLocation, error)
+}
+
+type locationService struct {
+	store          locationStore
+	anchorInterval time.Duration
+	// trailSize is how many recent pings are kept per tourist; 0 keeps none
+	trailSize int
+}
+
+var locations *locationService
+
+// initLocation runs after initDocumentCache. Anchoring is disabled with LOCATION_ANCHOR_INTERVAL=0.
+func initLocation() {
+	liveTTL := getEnvDuration("LOCATION_LIVE_TTL", 24*time.Hour)
+	var store locationStore = newMemoryLocationStore(liveTTL)
+	backend := "memory"
+	if documentCache != nil {
+		store, backend = redisLocationStore{documentCache, liveTTL}, "Redis"
+	}
+	locations = &locationService{
+		store:          store,
+		anchorInterval: getEnvDuration("LOCATION_ANCHOR_INTERVAL", 15*time.Minute),
+		trailSize:      max(getEnvInt("LOCATION_TRAIL_SIZE", 50), 0),
+	}
+	if locations.anchorInterval <= 0 {
+		log.Printf("📍 Live locations kept in %s, anchoring disabled", backend)
+		return
+	}
+	log.Printf("📍 Live locations kept in %s, anchoring digests every %s", backend, locations.anchorInterval)
+}
+
+// locationDigestLine is the canonical form of a ping in an anchor digest. Lines start with the UTC
+// time, so sorting them orders the pings chronologically.
+func locationDigestLine(ping LocationPing) string {
+	t, _ := time.Parse(time.RFC3339, ping.RecordedAt)
+	return fmt.Sprintf("%s|%.6f|%.6f|%.1f", t.UTC().Format(time.RFC3339), *ping.Latitude, *ping.Longitude, ping.Accuracy)
+}
+
+// parseLocationDigestLine reads a line written by locationDigestLine
+func parseLocationDigestLine(line string) (TrailPoint, bool) {
+	fields := strings.Split(line, "|")
+	if len(fields) != 4 {
+		return TrailPoint{}, false
+	}
+	var point TrailPoint
+	var err error
+	point.RecordedAt = fields[0]
+	if point.Latitude, err = strconv.ParseFloat(fields[1], 64); err != nil {
+		return TrailPoint{}, false
+	}
+	if point.Longitude, err = strconv.ParseFloat(fields[2], 64); err != nil {
+		return TrailPoint{}, false
+	}
+	if point.Accuracy, err = strconv.ParseFloat(fields[3], 64); err != nil {
+		return TrailPoint{}, false
+	}
+	return point, true
+}
+
+// sortTrail orders trail lines newest first and drops repeats
+func sortTrail(lines []string) []string {
+	sort.Sort(sort.Reverse(sort.StringSlice(lines)))
+	return slices.Compact(lines)
+}
+
+// locationDigest hashes the anchor ID and the sorted, de-duplicated ping lines, one per line. The
+// anchor ID salts the digest in the same way as SOS location hashes.
+func locationDigest(anchorID string, lines []string) (string, []string) {
+	sorted := append([]string(nil), lines...)
+	sort.Strings(sorted)
+	unique := sorted[:0]
+	for i, line := range sorted {
+		if i == 0 || line != sorted[i-1] {
+			unique = append(unique, line)
+		}
+	}
+	sum := sha256.Sum256([]byte(anchorID + "\n" + strings.Join(unique, "\n")))
+	return hex.EncodeToString(sum[:]), unique
+}
+
+// Ingest records a batch of pings: the latest becomes the live location and, when anchoring is on,
+// every ping is queued for the next anchor
+func (s *locationService) Ingest(ctx context.Context, req LocationPingsRequest) (*LocationPingsResult, error) {
+	if errs := req.Validate(); len(errs) > 0 {
+		return nil, errs
+	}
+	if err := requireConsent(ctx, req.DigitalID, purposeLocationTracking); err != nil {
+		return nil, err
+	}
+	// Fused positions replace the observations, so geofences and anomaly detection see them
+	var unresolved int
+	req.Pings, unresolved = fusion.resolve(req.Pings)
+	if len(req.Pings) == 0 {
+		return nil, ValidationErrors{{Field: "pings", Message: "could not be placed from their Wi-Fi or cell observations"}}
+	}
+
+	var latest LocationPing
+	var recordedAt time.Time
+	lines := make([]string, 0, len(req.Pings))
+	for _, ping := range req.Pings {
+		if t, _ := time.Parse(time.RFC3339, ping.RecordedAt); t.After(recordedAt) {
+			latest, recordedAt = ping, t
+		}
+		lines = append(lines, locationDigestLine(ping))
+	}
+	live, err := s.store.update(ctx, LiveLocation{
+		DigitalID:  req.DigitalID,
+		Latitude:   *latest.Latitude,
+		Longitude:  *latest.Longitude,
+		Accuracy:   latest.Accuracy,
+		Speed:      latest.Speed,
+		Heading:    latest.Heading,
+		Battery:    latest.Battery,
+		RecordedAt: recordedAt.UTC().Format(time.RFC3339),
+		ReceivedAt: time.Now().UTC().Format(time.RFC3339),
+		Source:     latest.Source,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if s.anchorInterval > 0 {
+		if err := s.store.appendPending(ctx, req.DigitalID, lines); err != nil {
+			return nil, err
+		}
+	}
+	if s.trailSize > 0 {
+		if err := s.store.appendTrail(ctx, req.DigitalID, lines, s.trailSize); err != nil {
+			return nil, err
+		}
+	}
+
+	result := &LocationPingsResult{DigitalID: req.DigitalID, Accepted: len(req.Pings), Unresolved: unresolved, Live: live}
+	if geofence != nil {
+		evaluation := geofence.evaluate(live.Latitude, live.Longitude, time.Now())
+		result.Geofence = &evaluation
+	}
+	if geofence != nil && zoneTracker != nil {
+		events, err := zoneTracker.track(ctx, req.DigitalID, req.Pings)
+		if err != nil {
+			return nil, err
+		}
+		zoneTracker.alert(ctx, events)
+		analytics.recordVisits(ctx, events)
+		result.Events = events
+		if alertRules != nil {
+			fired, err := alertRules.evaluate(ctx, req.DigitalID, live, events)
+			if err != nil {
+				return nil, err
+			}
+			if len(fired) > 0 {
+				go alertRules.raise(context.Background(), tenantFromContext(ctx).org, fired)
+			}
+			result.Alerts = fired
+		}
+	}
+	if operators != nil {
+		go operators.track(context.Background(), req.DigitalID, live)
+	}
+	if anomalies != nil {
+		detected, err := anomalies.observe(ctx, req.DigitalID, req.Pings)
+		if err != nil {
+			return nil, err
+		}
+		if len(detected) > 0 {
+			go anomalies.raise(context.Background(), detected)
+		}
+		result.Anomalies = detected
+	}
+	if safety != nil {
+		score, err := safety.update(ctx, req.DigitalID, live.Latitude, live.Longitude, result.Anomalies)
+		if err != nil {
+			return nil, err
+		}
+		result.Safety = &score
+	}
+	return result, nil
+}
+
+// Trail returns a tourist's most recent pings, newest first
+func (s *locationService) Trail(ctx context.Context, digitalID string) ([]TrailPoint, error) {
+	lines, err := s.store.trail(ctx, digitalID)
+	if err != nil {
+		return nil, err
+	}
+	points := make([]TrailPoint, 0, len(lines))
+	for _, line := range lines {
+		if point, ok := parseLocationDigestLine(line); ok {
+			points = append(points, point)
+		}
+	}
+	return points, nil
+}
+
+// run anchors pending pings every LOCATION_ANCHOR_INTERVAL on the default target. Each window is
+// claimed, so with several gateway instances only one anchors it.
+func (s *locationService) run(ctx context.Context) {
+	if s.anchorInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(s.anchorInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case tick := <-ticker.C:
+			window := tick.UTC().Truncate(s.anchorInterval)
+			if claimed, err := claimEvent(ctx, "location-anchor:"+window.Format(time.RFC3339), s.anchorInterval); err != nil || !claimed {
+				continue
+			}
+			if err := s.anchor(withTarget(ctx, defaultTarget), window); err != nil {
+				log.Printf("Failed to anchor location digests: %v", err)
+			}
+		}
+	}
+}
+
+// anchor drains the pending pings and records one digest per tourist. Pings whose transaction
+// fails are queued again for the next window.
+func (s *locationService) anchor(ctx context.Context, window time.Time) error {
+	pending, err := s.store.drainPending(ctx)
+	if err != nil || len(pending) == 0 {
+		return err
+	}
+
+	anchors := make([]LocationAnchor, 0, len(pending))
+	for digitalID, lines := range pending {
+		anchorID := "LOC:" + digitalID + ":" + window.Format("20060102T150405Z")
+		digest, unique := locationDigest(anchorID, lines)
+		anchors = append(anchors, LocationAnchor{
+			AnchorID:    anchorID,
+			DigitalID:   digitalID,
+			Digest:      digest,
+			PingCount:   len(unique),
+			FirstPingAt: strings.SplitN(unique[0], "|", 2)[0],
+			LastPingAt:  strings.SplitN(unique[len(unique)-1], "|", 2)[0],
+		})
+	}
+	sort.Slice(anchors, func(i, j int) bool { return anchors[i].DigitalID < anchors[j].DigitalID })
+
+	var errs []error
+	anchored := 0
+	for start := 0; start < len(anchors); start += maxLocationAnchorsPerTx {
+		chunk := anchors[start:min(start+maxLocationAnchorsPerTx, len(anchors))]
+		payload, err := json.Marshal(chunk)
+		if err == nil {
+			_, err = submitTransaction(ctx, "AnchorLocationDigests", string(payload))
+		}
+		if err != nil {
+			errs = append(errs, err)
+			for _, anchor := range chunk {
+				if requeueErr := s.store.appendPending(ctx, anchor.DigitalID, pending[anchor.DigitalID]); requeueErr != nil {
+					log.Printf("Dropped %d location pings for %s after a failed anchor: %v", len(pending[anchor.DigitalID]), anchor.DigitalID, requeueErr)
+				}
+			}
+			continue
+		}
+		anchored += len(chunk)
+	}
+	if anchored > 0 {
+		log.Printf("📍 Anchored location digests for %d tourists", anchored)
+	}
+	return errors.Join(errs...)
+}
+
+func (ledgerService) ListLocationAnchors(ctx context.Context, digitalID string) ([]LocationAnchor, error) {
+	if errs := validateDocumentID("digitalId", digitalID); len(errs) > 0 {
+		return nil, errs
+	}
+	result, err := evaluateTransaction(ctx, "GetLocationAnchors", digitalID)
+	if err != nil {
+		return nil, err
+	}
+	anchors, err := decodeDocument[[]LocationAnchor](result, "location anchor")
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(anchors, func(i, j int) bool { return anchors[i].FirstPingAt < anchors[j].FirstPingAt })
+	return anchors, nil
+}
+
+// redisLocationStore keeps each live position under its own key, so positions expire after
+// LOCATION_LIVE_TTL, and pending lines in a list per tourist. A geo set indexes the live positions
+// for area searches; members whose position expired are pruned as searches find them.
+type redisLocationStore struct {
+	redis   *redisClient
+	liveTTL time.Duration
+}
+
+func (s redisLocationStore) update(ctx context.Context, live LiveLocation) (LiveLocation, error) {
+	if current, err := s.latest(ctx, live.DigitalID); err != nil {
+		return LiveLocation{}, err
+	} else if current != nil && current.RecordedAt >= live.RecordedAt {
+		return *current, nil
+	}
+	data, err := json.Marshal(live)
+	if err != nil {
+		return LiveLocation{}, err
+	}
+	if err := s.redis.Set(ctx, locationLivePrefix+live.DigitalID, data, s.liveTTL); err != nil {
+		return LiveLocation{}, err
+	}
+	// Redis cannot index positions nearer the poles than 85.05°, which area searches then miss
+	if math.Abs(live.Latitude) <= maxGeoIndexLatitude {
+		if _, err := s.redis.Do(ctx, "GEOADD", locationGeoKey, strconv.FormatFloat(live.Longitude, 'f', -1, 64), strconv.FormatFloat(live.Latitude, 'f', -1, 64), live.DigitalID); err != nil {
+			return LiveLocation{}, err
+		}
+	}
+	return live, nil
+}
+
+func (s redisLocationStore) latest(ctx context.Context, digitalID string) (*LiveLocation, error) {
+	data, err := s.redis.Get(ctx, locationLivePrefix+digitalID)
+	if errors.Is(err, errRedisNil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var live LiveLocation
+	if err := json.Unmarshal(data, &live); err != nil {
+		return nil, err
+	}
+	return &live, nil
+}
+
+func (s redisLocationStore) appendPending(ctx context.Context, digitalID string, lines []string) error {
+	args := append([]string{"RPUSH", locationPendingPrefix + digitalID}, lines...)
+	if _, err := s.redis.Do(ctx, args...); err != nil {
+		return err
+	}
+	_, err := s.redis.Do(ctx, "SADD", locationPendingSetKey, digitalID)
+	return err
+}
+
+// drainPending renames each list before reading it, so pings that arrive meanwhile start a new list
+func (s redisLocationStore) drainPending(ctx context.Context) (map[string][]string, error) {
+	reply, err := s.redis.Do(ctx, "SMEMBERS", locationPendingSetKey)
+	if err != nil {
+		return nil, err
+	}
+	members, _ := reply.([]interface{})
+	pending := map[string][]string{}
+	for _, member := range members {
+		raw, _ := member.([]byte)
+		digitalID := string(raw)
+		if _, err := s.redis.Do(ctx, "SREM", locationPendingSetKey, digitalID); err != nil {
+			return pending, err
+		}
+		draining := locationPendingPrefix + digitalID + ":draining:" + strconv.FormatInt(time.Now().UnixNano(), 36)
+		if _, err := s.redis.Do(ctx, "RENAME", locationPendingPrefix+digitalID, draining); err != nil {
+			continue // nothing left to drain
+		}
+		reply, err := s.redis.Do(ctx, "LRANGE", draining, "0", "-1")
+		if err != nil {
+			return pending, err
+		}
+		items, _ := reply.([]interface{})
+		for _, item := range items {
+			line, _ := item.([]byte)
+			pending[digitalID] = append(pending[digitalID], string(line))
+		}
+		s.redis.Del(ctx, draining)
+	}
+	return pending, nil
+}
+
+// appendTrail pushes the lines onto the head of the trail; a batch that arrives late can push out
+// newer lines, which the trail's size leaves room for
+func (s redisLocationStore) appendTrail(ctx context.Context, digitalID string, lines []string, keep int) error {
+	key := locationTrailPrefix + digitalID
+	if _, err := s.redis.Do(ctx, append([]string{"LPUSH", key}, lines...)...); err != nil {
+		return err
+	}
+	if _, err := s.redis.Do(ctx, "LTRIM", key, "0", strconv.Itoa(keep-1)); err != nil {
+		return err
+	}
+	_, err := s.redis.Do(ctx, "PEXPIRE", key, strconv.FormatInt(s.liveTTL.Milliseconds(), 10))
+	return err
+}
+
+func (s redisLocationStore) trail(ctx context.Context, digitalID string) ([]string, error) {
+	reply, err := s.redis.Do(ctx, "LRANGE", locationTrailPrefix+digitalID, "0", "-1")
+	if err != nil {
+		return nil, err
+	}
+	items, _ := reply.([]interface{})
+	lines := make([]string, 0, len(items))
+	for _, item := range items {
+		line, _ := item.([]byte)
+		lines = append(lines, string(line))
+	}
+	return sortTrail(lines), nil
+}
+
+func (s redisLocationStore) remove(ctx context.Context, digitalID string) (int, error) {
+	removed := 0
+	for _, command := range [][]string{{"EXISTS", locationLivePrefix + digitalID}, {"LLEN", locationPendingPrefix + digitalID}, {"LLEN", locationTrailPrefix + digitalID}} {
+		reply, err := s.redis.Do(ctx, command...)
+		if err != nil {
+			return 0, err
+		}
+		count, _ := reply.(int64)
+		removed += int(count)
+	}
+	if _, err := s.redis.Do(ctx, "SREM", locationPendingSetKey, digitalID); err != nil {
+		return 0, err
+	}
+	if _, err := s.redis.Do(ctx, "ZREM", locationGeoKey, digitalID); err != nil {
+		return 0, err
+	}
+	return removed, s.redis.Del(ctx, locationLivePrefix+digitalID, locationPendingPrefix+digitalID, locationTrailPrefix+digitalID)
+}
+
+// within searches the circle around the box's centre that covers its corners, then keeps the
+// positions inside the box
+func (s redisLocationStore) within(ctx context.Context, box bounds, since time.Time) ([]LiveLocation, error) {
+	lat, lng := (box.minLat+box.maxLat)/2, (box.minLng+box.maxLng)/2
+	radiusKm := math.Max(haversineKm(lat, lng, box.minLat, box.minLng), haversineKm(lat, lng, box.maxLat, box.minLng))
+	reply, err := s.redis.Do(ctx, "GEOSEARCH", locationGeoKey, "FROMLONLAT", strconv.FormatFloat(lng, 'f', -1, 64), strconv.FormatFloat(lat, 'f', -1, 64),
+		"BYRADIUS", strconv.FormatFloat(radiusKm+0.01, 'f', 3, 64), "km")
+	if err != nil {
+		return nil, err
+	}
+	members, _ := reply.([]interface{})
+	found := []LiveLocation{}
+	for _, member := range members {
+		raw, _ := member.([]byte)
+		live, err := s.latest(ctx, string(raw))
+		if err != nil {
+			return nil, err
+		}
+		if live == nil {
+			if _, err := s.redis.Do(ctx, "ZREM", locationGeoKey, string(raw)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if recorded, _ := time.Parse(time.RFC3339, live.RecordedAt); box.contains(live.Longitude, live.Latitude) && !recorded.Before(since) {
+			found = append(found, *live)
+		}
+	}
+	return found, nil
+}
+
+// memoryLocationStore serves a single gateway instance
+type memoryLocationStore struct {
+	liveTTL time.Duration
+
+	mu      sync.Mutex
+	live    map[string]LiveLocation
+	pending map[string][]string
+	trails  map[string][]string
+}
+
+func newMemoryLocationStore(liveTTL time.Duration) *memoryLocationStore {
+	return &memoryLocationStore{liveTTL: liveTTL, live: map[string]LiveLocation{}, pending: map[string][]string{}, trails: map[string][]string{}}
+}
+
+func (s *memoryLocationStore) update(_ context.Context, live LiveLocation) (LiveLocation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if current, ok := s.live[live.DigitalID]; ok && current.RecordedAt >= live.RecordedAt {
+		return current, nil
+	}
+	s.live[live.DigitalID] = live
+	return live, nil
+}
+
+func (s *memoryLocationStore) latest(_ context.Context, digitalID string) (*LiveLocation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	live, ok := s.live[digitalID]
+	if !ok {
+		return nil, nil
+	}
+	if received, _ := time.Parse(time.RFC3339, live.ReceivedAt); time.Since(received) > s.liveTTL {
+		delete(s.live, digitalID)
+		return nil, nil
+	}
+	return &live, nil
+}
+
+func (s *memoryLocationStore) appendPending(_ context.Context, digitalID string, lines []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending[digitalID] = append(s.pending[digitalID], lines...)
+	return nil
+}
+
+func (s *memoryLocationStore) drainPending(context.Context) (map[string][]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pending := s.pending
+	s.pending = map[string][]string{}
+	return pending, nil
+}
+
+func (s *memoryLocationStore) appendTrail(_ context.Context, digitalID string, lines []string, keep int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	trail := sortTrail(append(slices.Clone(s.trails[digitalID]), lines...))
+	s.trails[digitalID] = trail[:min(len(trail), keep)]
+	return nil
+}
+
+// trail expires with the live position
+func (s *memoryLocationStore) trail(_ context.Context, digitalID string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if live, ok := s.live[digitalID]; !ok {
+		return nil, nil
+	} else if received, _ := time.Parse(time.RFC3339, live.ReceivedAt); time.Since(received) > s.liveTTL {
+		delete(s.trails, digitalID)
+		return nil, nil
+	}
+	return slices.Clone(s.trails[digitalID]), nil
+}
+
+func (s *memoryLocationStore) remove(_ context.Context, digitalID string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	removed := len(s.pending[digitalID]) + len(s.trails[digitalID])
+	if _, ok := s.live[digitalID]; ok {
+		removed++
+	}
+	delete(s.live, digitalID)
+	delete(s.pending, digitalID)
+	delete(s.trails, digitalID)
+	return removed, nil
+}
+
+func (s *memoryLocationStore) within(_ context.Context, box bounds, since time.Time) ([]LiveLocation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	found := []LiveLocation{}
+	for _, live := range s.live {
+		received, _ := time.Parse(time.RFC3339, live.ReceivedAt)
+		recorded, _ := time.Parse(time.RFC3339, live.RecordedAt)
+		if time.Since(received) <= s.liveTTL && box.contains(live.Longitude, live.Latitude) && !recorded.Before(since) {
+			found = append(found, live)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].DigitalID < found[j].DigitalID })
+	return found, nil
+}
+
+func ingestLocationPings(c *gin.Context) {
+	var req LocationPingsRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+	setAuditTarget(c, req.DigitalID)
+
+	result, err := locations.Ingest(c.Request.Context(), req)
+	if err != nil {
+		respondServiceError(c, "Failed to record location pings", err)
+		return
+	}
+
+	respondData(c, http.StatusAccepted, result)
+}
+
+func getLiveLocation(c *gin.Context) {
+	id, ok := validPathID(c, "digitalId")
+	if !ok {
+		return
+	}
+
+	live, err := locations.store.latest(c.Request.Context(), id)
+	if err != nil {
+		respondServiceError(c, "Failed to read live location", err)
+		return
+	}
+	if live == nil {
+		respondError(c, http.StatusNotFound, errCodeNotFound, "No recent location for "+id)
+		return
+	}
+
+	respondData(c, http.StatusOK, live)
+}
+
+func getLocationAnchors(c *gin.Context) {
+	id, ok := validPathID(c, "digitalId")
+	if !ok {
+		return
+	}
+
+	anchors, err := ledger.ListLocationAnchors(c.Request.Context(), id)
+	if err != nil {
+		respondServiceError(c, "Failed to list location anchors", err)
+		return
+	}
+
+	respondData(c, http.StatusOK, anchors)
+}
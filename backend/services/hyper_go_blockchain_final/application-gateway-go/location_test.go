@@ -0,0 +1,70 @@
+package gateway
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLocationPingIngest(t *testing.T) {
+	store := newMemoryLocationStore(time.Hour)
+	s := &locationService{store: store, anchorInterval: 15 * time.Minute, trailSize: 3}
+	ctx := context.Background()
+	lat, lng := 25.5788, 91.8933
+	now := time.Now().UTC().Truncate(time.Second)
+	ping := func(ago time.Duration, latitude float64) LocationPing {
+		return LocationPing{Latitude: &latitude, Longitude: &lng, Accuracy: 12, RecordedAt: now.Add(-ago).In(time.FixedZone("IST", 19800)).Format(time.RFC3339)}
+	}
+
+	// Pings arrive out of order and in local time; the latest by instant becomes the live location
+	result, err := s.Ingest(ctx, LocationPingsRequest{DigitalID: "did:sih:tourist1", Pings: []LocationPing{
+		ping(time.Minute, lat+0.001), ping(0, lat), ping(2*time.Minute, lat+0.002),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Accepted != 3 || result.Live.Latitude != lat || result.Live.RecordedAt != now.Format(time.RFC3339) {
+		t.Fatalf("unexpected result %+v", result)
+	}
+
+	// A late upload of older pings is queued for anchoring but leaves the live location alone
+	retry := ping(time.Minute, lat+0.001)
+	if _, err := s.Ingest(ctx, LocationPingsRequest{DigitalID: "did:sih:tourist1", Pings: []LocationPing{retry, ping(10*time.Minute, lat+0.01)}}); err != nil {
+		t.Fatal(err)
+	}
+	if live, _ := store.latest(ctx, "did:sih:tourist1"); live == nil || live.Latitude != lat {
+		t.Errorf("expected the live location to stay at the latest ping, got %+v", live)
+	}
+
+	// The trail keeps the latest three pings, newest first, whatever order they arrived in
+	trail, err := s.Trail(ctx, "did:sih:tourist1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trail) != 3 || trail[0].RecordedAt != now.Format(time.RFC3339) || trail[2].Latitude != lat+0.002 || trail[0].Accuracy != 12 {
+		t.Errorf("unexpected trail %+v", trail)
+	}
+
+	pending, _ := store.drainPending(ctx)
+	digest, unique := locationDigest("LOC:did:sih:tourist1:20250920T130000Z", pending["did:sih:tourist1"])
+	if len(unique) != 4 || unique[0] > unique[3] {
+		t.Fatalf("expected four distinct pings in time order, got %v", unique)
+	}
+	reversed := []string{unique[3], unique[2], unique[1], unique[0], unique[1]}
+	if again, _ := locationDigest("LOC:did:sih:tourist1:20250920T130000Z", reversed); again != digest {
+		t.Errorf("digest depends on arrival order or duplicates")
+	}
+	if other, _ := locationDigest("LOC:did:sih:tourist1:20250920T131500Z", unique); other == digest {
+		t.Errorf("digest is not salted with the anchor ID")
+	}
+	if again, _ := store.drainPending(ctx); len(again) != 0 {
+		t.Errorf("expected draining to empty the queue, got %v", again)
+	}
+
+	future := ping(-time.Hour, lat)
+	if _, err := s.Ingest(ctx, LocationPingsRequest{DigitalID: "did:sih:tourist1", Pings: []LocationPing{future, {}}}); err == nil {
+		t.Error("expected future and incomplete pings to be rejected")
+	} else if errs, ok := err.(ValidationErrors); !ok || len(errs) != 4 {
+		t.Errorf("expected four field errors, got %v", err)
+	}
+}
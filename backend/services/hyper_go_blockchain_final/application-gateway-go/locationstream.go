@@ -0,0 +1,197 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+	"time"
+
+	sihv1 "assetTransfer/proto/sih/v1"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// A stream that sends nothing for this many heartbeat intervals is closed
+const locationStreamMissedHeartbeats = 3
+
+type locationStream = grpc.BidiStreamingServer[sihv1.LocationStreamRequest, sihv1.LocationStreamResponse]
+
+// grpcLocationServer serves LocationService. A reader goroutine queues each stream's requests, at
+// most maxInFlight of them, and the stream's own goroutine ingests them and sends every response.
+// A client that sends faster than its pings are stored, or stops reading its alerts, therefore
+// stops being read, and HTTP/2 flow control blocks its sends.
+type grpcLocationServer struct {
+	sihv1.UnimplementedLocationServiceServer
+	heartbeat   time.Duration
+	maxInFlight int
+}
+
+func newGRPCLocationServer() *grpcLocationServer {
+	return &grpcLocationServer{
+		heartbeat:   getEnvDuration("LOCATION_STREAM_HEARTBEAT", 30*time.Second),
+		maxInFlight: max(getEnvInt("LOCATION_STREAM_MAX_IN_FLIGHT", 8), 1),
+	}
+}
+
+func (s *grpcLocationServer) StreamLocation(stream locationStream) error {
+	if locations == nil {
+		return status.Error(codes.Unavailable, "location tracking is not enabled")
+	}
+	ctx := stream.Context()
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	start := first.GetStart()
+	if start == nil {
+		return status.Error(codes.FailedPrecondition, "the first message on a location stream must be start")
+	}
+	digitalID := start.GetDigitalId()
+	var v fieldValidator
+	v.digitalID("digital_id", digitalID)
+	if len(v.errors) > 0 {
+		return grpcError("Failed to start location stream", v.errors)
+	}
+	if err := requireConsent(ctx, digitalID, purposeLocationTracking); err != nil {
+		return grpcError("Failed to start location stream", err)
+	}
+	err = stream.Send(&sihv1.LocationStreamResponse{Message: &sihv1.LocationStreamResponse_Accepted{Accepted: &sihv1.LocationStreamAccepted{
+		DigitalId:        digitalID,
+		HeartbeatSeconds: uint32(s.heartbeat / time.Second),
+		MaxInFlight:      uint32(s.maxInFlight),
+	}}})
+	if err != nil {
+		return err
+	}
+
+	// The reader closes requests when the client half-closes the stream or it fails; recvErr is
+	// read only after that
+	requests := make(chan *sihv1.LocationStreamRequest, s.maxInFlight)
+	var recvErr error
+	var lastReceived atomic.Int64
+	lastReceived.Store(time.Now().UnixNano())
+	go func() {
+		defer close(requests)
+		for {
+			req, err := stream.Recv()
+			if err != nil {
+				recvErr = err
+				return
+			}
+			lastReceived.Store(time.Now().UnixNano())
+			select {
+			case requests <- req:
+			case <-ctx.Done():
+				recvErr = status.FromContextError(ctx.Err()).Err()
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(s.heartbeat)
+	defer ticker.Stop()
+	lastSent := time.Now()
+	for {
+		select {
+		case req, ok := <-requests:
+			if !ok {
+				if errors.Is(recvErr, io.EOF) {
+					return nil
+				}
+				return recvErr
+			}
+			responses, err := s.handle(ctx, digitalID, req)
+			if err != nil {
+				return err
+			}
+			for _, resp := range responses {
+				if err := stream.Send(resp); err != nil {
+					return err
+				}
+			}
+			lastSent = time.Now()
+		case now := <-ticker.C:
+			if idle := now.Sub(time.Unix(0, lastReceived.Load())); idle >= locationStreamMissedHeartbeats*s.heartbeat {
+				return status.Errorf(codes.DeadlineExceeded, "no heartbeat or pings for %s", idle.Round(time.Second))
+			}
+			if now.Sub(lastSent) >= s.heartbeat {
+				if err := stream.Send(locationHeartbeat()); err != nil {
+					return err
+				}
+				lastSent = now
+			}
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		}
+	}
+}
+
+// handle answers a heartbeat, or ingests a batch and returns its acknowledgement followed by the
+// zone alerts it caused
+func (s *grpcLocationServer) handle(ctx context.Context, digitalID string, req *sihv1.LocationStreamRequest) ([]*sihv1.LocationStreamResponse, error) {
+	var batch *sihv1.LocationPingBatch
+	switch msg := req.GetMessage().(type) {
+	case *sihv1.LocationStreamRequest_Heartbeat:
+		return []*sihv1.LocationStreamResponse{locationHeartbeat()}, nil
+	case *sihv1.LocationStreamRequest_Pings:
+		batch = msg.Pings
+	default:
+		return nil, status.Error(codes.InvalidArgument, "expected pings or a heartbeat after start")
+	}
+
+	pings := make([]LocationPing, 0, len(batch.GetPings()))
+	for _, ping := range batch.GetPings() {
+		pings = append(pings, locationPingFromMessage(ping))
+	}
+	result, err := locations.Ingest(ctx, LocationPingsRequest{DigitalID: digitalID, Pings: pings})
+	if err != nil {
+		return nil, grpcError("Failed to record location pings", err)
+	}
+
+	ack := &sihv1.LocationPingAck{Sequence: batch.GetSequence(), Accepted: uint32(result.Accepted)}
+	if result.Geofence != nil {
+		ack.RiskLevel = result.Geofence.RiskLevel
+	}
+	if result.Safety != nil {
+		score := int32(result.Safety.Score)
+		ack.SafetyScore = &score
+	}
+	responses := []*sihv1.LocationStreamResponse{{Message: &sihv1.LocationStreamResponse_Ack{Ack: ack}}}
+	for _, e := range result.Events {
+		responses = append(responses, &sihv1.LocationStreamResponse{Message: &sihv1.LocationStreamResponse_Alert{Alert: &sihv1.ZoneAlert{
+			Type:         e.Type,
+			ZoneId:       e.ZoneID,
+			Name:         e.Name,
+			RiskLevel:    e.RiskLevel,
+			Latitude:     e.Latitude,
+			Longitude:    e.Longitude,
+			At:           e.At,
+			EnteredAt:    e.EnteredAt,
+			DwellSeconds: e.DwellSeconds,
+		}}})
+	}
+	return responses, nil
+}
+
+func locationPingFromMessage(ping *sihv1.LocationPing) LocationPing {
+	latitude, longitude := ping.GetLatitude(), ping.GetLongitude()
+	converted := LocationPing{Latitude: &latitude, Longitude: &longitude, Accuracy: ping.GetAccuracy(), Speed: ping.Speed, Heading: ping.Heading, RecordedAt: ping.GetRecordedAt()}
+	if ping.Battery != nil {
+		battery := int(ping.GetBattery())
+		converted.Battery = &battery
+	}
+	return converted
+}
+
+func locationHeartbeat() *sihv1.LocationStreamResponse {
+	return &sihv1.LocationStreamResponse{Message: &sihv1.LocationStreamResponse_Heartbeat{Heartbeat: &sihv1.LocationHeartbeat{SentAt: time.Now().UTC().Format(time.RFC3339)}}}
+}
@@ -0,0 +1,98 @@
+package gateway
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	sihv1 "assetTransfer/proto/sih/v1"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestLocationStream(t *testing.T) {
+	previousLocations, previousTracker := locations, zoneTracker
+	locations = &locationService{store: newMemoryLocationStore(time.Hour)}
+	geofence = newGeofenceIndex()
+	zoneTracker = &geofenceTracker{store: newMemoryPresenceStore(time.Hour), alertMinRisk: zoneRiskLevel("high")}
+	defer func() { locations, zoneTracker, geofence = previousLocations, previousTracker, nil }()
+	zone, err := newIndexedZone(ZoneDocument{ZoneID: "falls", Name: "Elephant Falls gorge", Geometry: `{"type":"Circle","coordinates":[91.82,25.54],"radius":300}`, RiskLevel: "restricted"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	geofence.put(zone)
+
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	sihv1.RegisterLocationServiceServer(server, &grpcLocationServer{heartbeat: 50 * time.Millisecond, maxInFlight: 2})
+	go server.Serve(listener)
+	defer server.Stop()
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := sihv1.NewLocationServiceClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	stream, _ := client.StreamLocation(ctx)
+	stream.Send(&sihv1.LocationStreamRequest{Message: &sihv1.LocationStreamRequest_Heartbeat{Heartbeat: &sihv1.LocationHeartbeat{}}})
+	if _, err := stream.Recv(); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("expected a stream without start to be refused, got %v", err)
+	}
+
+	stream, _ = client.StreamLocation(ctx)
+	stream.Send(&sihv1.LocationStreamRequest{Message: &sihv1.LocationStreamRequest_Start{Start: &sihv1.StartLocationStream{DigitalId: "did:sih:tourist_001"}}})
+	resp, err := stream.Recv()
+	if err != nil || resp.GetAccepted().GetMaxInFlight() != 2 {
+		t.Fatalf("expected the stream accepted with its limits, got %v, %v", resp, err)
+	}
+
+	battery := int32(64)
+	stream.Send(&sihv1.LocationStreamRequest{Message: &sihv1.LocationStreamRequest_Pings{Pings: &sihv1.LocationPingBatch{Sequence: 7, Pings: []*sihv1.LocationPing{
+		{Latitude: 25.5, Longitude: 91.9, Accuracy: 10, RecordedAt: time.Now().Add(-2 * time.Minute).UTC().Format(time.RFC3339)},
+		{Latitude: 25.54, Longitude: 91.82, Accuracy: 8, Battery: &battery, RecordedAt: time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)},
+	}}}})
+	resp, _ = stream.Recv()
+	if ack := resp.GetAck(); ack.GetSequence() != 7 || ack.GetAccepted() != 2 || ack.GetRiskLevel() != "restricted" {
+		t.Fatalf("expected the batch acknowledged at the zone's risk, got %v", resp)
+	}
+	resp, _ = stream.Recv()
+	if alert := resp.GetAlert(); alert.GetType() != geofenceZoneEntry || alert.GetZoneId() != "falls" {
+		t.Errorf("expected the zone entry after the acknowledgement, got %v", resp)
+	}
+	if live, _ := locations.store.latest(ctx, "did:sih:tourist_001"); live == nil || live.Battery == nil || *live.Battery != 64 {
+		t.Errorf("expected the latest ping stored as the live location, got %+v", live)
+	}
+
+	// An idle client gets heartbeats, then the stream is closed
+	heartbeats := 0
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			if status.Code(err) != codes.DeadlineExceeded || heartbeats == 0 {
+				t.Errorf("expected heartbeats and then a closed stream, got %d and %v", heartbeats, err)
+			}
+			break
+		}
+		if resp.GetHeartbeat() != nil {
+			heartbeats++
+		}
+	}
+
+	stream, _ = client.StreamLocation(ctx)
+	stream.Send(&sihv1.LocationStreamRequest{Message: &sihv1.LocationStreamRequest_Start{Start: &sihv1.StartLocationStream{DigitalId: "did:sih:tourist_001"}}})
+	stream.Recv()
+	stream.Send(&sihv1.LocationStreamRequest{Message: &sihv1.LocationStreamRequest_Pings{Pings: &sihv1.LocationPingBatch{Sequence: 1, Pings: []*sihv1.LocationPing{{Latitude: 95, RecordedAt: "yesterday"}}}}})
+	if _, err := stream.Recv(); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected an invalid batch to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,858 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	lostFoundLost     = "lost"
+	lostFoundFound    = "found"
+	lostFoundOpen     = "open"
+	lostFoundReturned = "returned"
+	lostFoundRejected = "rejected"
+
+	maxLostFoundDescription = 1000
+	maxLostFoundText        = 128
+	maxLostFoundPhotos      = 10
+	maxLostFoundPhotoBytes  = 10 << 20
+	maxLostFoundCandidates  = 20
+	defaultLostFoundRadius  = 2.0
+	maxLostFoundRadius      = 50.0
+
+	// lostFoundTimeSlack allows for a tourist misremembering when an item was lost, so an item found
+	// shortly before is still proposed
+	lostFoundTimeSlack = 24 * time.Hour
+)
+
+var (
+	lostFoundKinds       = []string{lostFoundLost, lostFoundFound}
+	lostFoundStatuses    = []string{lostFoundOpen, lostFoundReturned, "closed"}
+	lostFoundResolutions = []string{lostFoundReturned, lostFoundRejected}
+	lostFoundCategories  = []string{"documents", "electronics", "bags", "jewellery", "clothing", "keys", "wallet", "other"}
+)
+
+// LostFoundItemRequest reports an item lost by a tourist or handed in at a help center. The
+// location is recorded on the ledger as a geohash cell, never as exact coordinates.
+type LostFoundItemRequest struct {
+	Kind         string   `json:"kind" binding:"required"`
+	Category     string   `json:"category" binding:"required"`
+	Description  string   `json:"description" binding:"required"`
+	Color        string   `json:"color"`
+	Brand        string   `json:"brand"`
+	LocationName string   `json:"locationName" binding:"required"`
+	Latitude     *float64 `json:"latitude"`
+	Longitude    *float64 `json:"longitude"`
+	OccurredAt   string   `json:"occurredAt" binding:"required"`
+	ReporterDID  string   `json:"reporterDID"`
+	Actor        string   `json:"actor" binding:"required"`
+}
+
+func (r LostFoundItemRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.oneOf("kind", r.Kind, lostFoundKinds)
+	v.oneOf("category", r.Category, lostFoundCategories)
+	lostFoundText(&v, "description", r.Description, maxLostFoundDescription, true)
+	lostFoundText(&v, "color", r.Color, maxLostFoundText, false)
+	lostFoundText(&v, "brand", r.Brand, maxLostFoundText, false)
+	lostFoundText(&v, "locationName", r.LocationName, maxLostFoundText, true)
+	if (r.Latitude == nil) != (r.Longitude == nil) {
+		v.add("longitude", "must be given together with latitude")
+	}
+	if r.Latitude != nil && (*r.Latitude < -90 || *r.Latitude > 90) {
+		v.add("latitude", "must be between -90 and 90")
+	}
+	if r.Longitude != nil && (*r.Longitude < -180 || *r.Longitude > 180) {
+		v.add("longitude", "must be between -180 and 180")
+	}
+	if at, ok := v.rfc3339("occurredAt", r.OccurredAt); ok && at.After(time.Now()) {
+		v.add("occurredAt", "must not be in the future")
+	}
+	if r.ReporterDID != "" {
+		v.digitalID("reporterDID", r.ReporterDID)
+	}
+	v.identifier("actor", r.Actor)
+	return v.errors
+}
+
+// lostFoundText checks a free-text descriptor is printable and short
+func lostFoundText(v *fieldValidator, field, value string, max int, required bool) {
+	trimmed := strings.TrimSpace(value)
+	if trimmed == "" {
+		if required {
+			v.add(field, "must not be empty")
+		}
+		return
+	}
+	if len(trimmed) > max || strings.IndexFunc(trimmed, func(r rune) bool { return !unicode.IsPrint(r) }) >= 0 {
+		v.add(field, "must be at most %d printable characters", max)
+	}
+}
+
+// LostFoundSearchRequest filters items for help-center staff. Q matches words of the descriptors;
+// lat and lng search within radiusKm of a point.
+type LostFoundSearchRequest struct {
+	Kind     string   `form:"kind"`
+	Category string   `form:"category"`
+	Status   string   `form:"status"`
+	Q        string   `form:"q"`
+	Lat      *float64 `form:"lat"`
+	Lng      *float64 `form:"lng"`
+	RadiusKm float64  `form:"radiusKm"`
+	From     string   `form:"from"`
+	To       string   `form:"to"`
+}
+
+func (r LostFoundSearchRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	if r.Kind != "" {
+		v.oneOf("kind", r.Kind, lostFoundKinds)
+	}
+	if r.Category != "" {
+		v.oneOf("category", r.Category, lostFoundCategories)
+	}
+	if r.Status != "" {
+		v.oneOf("status", r.Status, lostFoundStatuses)
+	}
+	lostFoundText(&v, "q", r.Q, maxLostFoundText, false)
+	if (r.Lat == nil) != (r.Lng == nil) {
+		v.add("lng", "must be given together with lat")
+	}
+	if r.Lat != nil && (*r.Lat < -90 || *r.Lat > 90) {
+		v.add("lat", "must be between -90 and 90")
+	}
+	if r.Lng != nil && (*r.Lng < -180 || *r.Lng > 180) {
+		v.add("lng", "must be between -180 and 180")
+	}
+	if r.RadiusKm < 0 || r.RadiusKm > maxLostFoundRadius {
+		v.add("radiusKm", "must be between 0 and %g", maxLostFoundRadius)
+	}
+	v.timeRange(r.From, r.To)
+	return v.errors
+}
+
+// LostFoundMatchRequest resolves whether a lost and a found item are the same. A returned match
+// closes both items.
+type LostFoundMatchRequest struct {
+	LostItemID  string `json:"lostItemID" binding:"required"`
+	FoundItemID string `json:"foundItemID" binding:"required"`
+	Resolution  string `json:"resolution" binding:"required"`
+	Note        string `json:"note"`
+	Actor       string `json:"actor" binding:"required"`
+}
+
+func (r LostFoundMatchRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.identifier("lostItemID", r.LostItemID)
+	v.identifier("foundItemID", r.FoundItemID)
+	v.oneOf("resolution", r.Resolution, lostFoundResolutions)
+	lostFoundText(&v, "note", r.Note, maxLostFoundDescription, false)
+	v.identifier("actor", r.Actor)
+	return v.errors
+}
+
+// CloseLostFoundItemRequest withdraws an item without a match
+type CloseLostFoundItemRequest struct {
+	Reason string `json:"reason" binding:"required"`
+	Actor  string `json:"actor" binding:"required"`
+}
+
+func (r CloseLostFoundItemRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	lostFoundText(&v, "reason", r.Reason, maxLostFoundText, true)
+	v.identifier("actor", r.Actor)
+	return v.errors
+}
+
+// LostFoundPhotoRequest holds the form field sent alongside a photo
+type LostFoundPhotoRequest struct {
+	Actor string `json:"actor" binding:"required"`
+}
+
+func (r LostFoundPhotoRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.identifier("actor", r.Actor)
+	return v.errors
+}
+
+// LostFoundPhoto is a photo of an item, anchored by its hash
+type LostFoundPhoto struct {
+	PhotoHash string `json:"photo_hash"`
+	MediaType string `json:"media_type"`
+	AddedBy   string `json:"added_by"`
+	AddedAt   string `json:"added_at"`
+}
+
+// LostFoundItem is a lost or found item as recorded on the ledger
+type LostFoundItem struct {
+	DocType      string           `json:"doc_type"`
+	ItemID       string           `json:"item_id"`
+	Kind         string           `json:"kind"`
+	Category     string           `json:"category"`
+	Description  string           `json:"description"`
+	Color        string           `json:"color,omitempty"`
+	Brand        string           `json:"brand,omitempty"`
+	LocationName string           `json:"location_name"`
+	Geohash      string           `json:"geohash,omitempty"`
+	OccurredAt   string           `json:"occurred_at"`
+	ReporterDID  string           `json:"reporter_did,omitempty"`
+	Photos       []LostFoundPhoto `json:"photos"`
+	Status       string           `json:"status"`
+	MatchID      string           `json:"match_id,omitempty"`
+	ClosedReason string           `json:"closed_reason,omitempty"`
+	ReportedBy   string           `json:"reported_by"`
+	ReportedAt   string           `json:"reported_at"`
+	UpdatedBy    string           `json:"updated_by"`
+	UpdatedAt    string           `json:"updated_at"`
+	OwnerOrg     string           `json:"owner_org,omitempty"`
+	TxID         string           `json:"tx_id"`
+}
+
+// visibleTo hides the reporter's DID from organizations other than the one that took the report;
+// the rest of the item is shared so any help center can match it
+func (item LostFoundItem) visibleTo(t tenant) LostFoundItem {
+	if !t.owns(item.OwnerOrg) {
+		item.ReporterDID = ""
+	}
+	return item
+}
+
+// LostFoundMatch is a match resolution as recorded on the ledger
+type LostFoundMatch struct {
+	DocType     string `json:"doc_type"`
+	MatchID     string `json:"match_id"`
+	LostItemID  string `json:"lost_item_id"`
+	FoundItemID string `json:"found_item_id"`
+	Resolution  string `json:"resolution"`
+	Note        string `json:"note,omitempty"`
+	ResolvedBy  string `json:"resolved_by"`
+	ResolvedAt  string `json:"resolved_at"`
+	OwnerOrg    string `json:"owner_org,omitempty"`
+	TxID        string `json:"tx_id"`
+}
+
+// LostFoundCandidate is an open item of the other kind that may be the same as the one matched.
+// Reasons lists the descriptors that agree.
+type LostFoundCandidate struct {
+	Item    LostFoundItem `json:"item"`
+	Score   float64       `json:"score"`
+	Reasons []string      `json:"reasons"`
+}
+
+func newLostFoundID(prefix string, now time.Time) string {
+	suffix := make([]byte, 3)
+	rand.Read(suffix)
+	return fmt.Sprintf("%s-%s-%s", prefix, now.UTC().Format("20060102T150405Z"), hex.EncodeToString(suffix))
+}
+
+// lostFoundPhotoKey is where a photo lives in the evidence store
+func lostFoundPhotoKey(itemID, photoHash string) string {
+	return fmt.Sprintf("lostfound/%s/%s", itemID, photoHash)
+}
+
+// lostFoundPhotoTypes returns the image types evidence uploads accept
+func lostFoundPhotoTypes() []string {
+	var types []string
+	for _, mediaType := range uploads.mediaTypes {
+		if strings.HasPrefix(mediaType, "image/") {
+			types = append(types, mediaType)
+		}
+	}
+	return types
+}
+
+// lostFoundWords returns the distinct words of at least three letters in the item's descriptors
+func lostFoundWords(texts ...string) map[string]bool {
+	words := map[string]bool{}
+	for _, text := range texts {
+		for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }) {
+			if len([]rune(word)) >= 3 {
+				words[word] = true
+			}
+		}
+	}
+	return words
+}
+
+// matchesLostFoundSearch applies the filters the chaincode query cannot
+func matchesLostFoundSearch(item LostFoundItem, req LostFoundSearchRequest) bool {
+	if req.Q != "" {
+		words := lostFoundWords(item.Description, item.Color, item.Brand, item.LocationName)
+		for word := range lostFoundWords(req.Q) {
+			if !words[word] {
+				return false
+			}
+		}
+	}
+	if req.From != "" && item.OccurredAt < req.From || req.To != "" && item.OccurredAt >= req.To {
+		return false
+	}
+	if req.Lat != nil {
+		cell, ok := geohashBounds(item.Geohash)
+		if !ok {
+			return false
+		}
+		radius := req.RadiusKm
+		if radius == 0 {
+			radius = defaultLostFoundRadius
+		}
+		if haversineKm(*req.Lat, *req.Lng, (cell.minLat+cell.maxLat)/2, (cell.minLng+cell.maxLng)/2) > radius {
+			return false
+		}
+	}
+	return true
+}
+
+// scoreLostFoundCandidate scores how alike a lost and a found item are, or returns false when the
+// found item was handed in well before the other was lost
+func scoreLostFoundCandidate(item, other LostFoundItem) (LostFoundCandidate, bool) {
+	lost, found := item, other
+	if item.Kind == lostFoundFound {
+		lost, found = other, item
+	}
+	lostAt, _ := time.Parse(time.RFC3339, lost.OccurredAt)
+	foundAt, _ := time.Parse(time.RFC3339, found.OccurredAt)
+	if foundAt.Before(lostAt.Add(-lostFoundTimeSlack)) {
+		return LostFoundCandidate{}, false
+	}
+
+	candidate := LostFoundCandidate{Item: other, Reasons: []string{}}
+	score := 0.0
+	if item.Color != "" && strings.EqualFold(strings.TrimSpace(item.Color), strings.TrimSpace(other.Color)) {
+		score += 2
+		candidate.Reasons = append(candidate.Reasons, "color")
+	}
+	if item.Brand != "" && strings.EqualFold(strings.TrimSpace(item.Brand), strings.TrimSpace(other.Brand)) {
+		score += 2
+		candidate.Reasons = append(candidate.Reasons, "brand")
+	}
+	a, b := lostFoundWords(item.Description), lostFoundWords(other.Description)
+	shared := 0
+	for word := range a {
+		if b[word] {
+			shared++
+		}
+	}
+	if shared > 0 {
+		score += 3 * float64(shared) / float64(len(a)+len(b)-shared)
+		candidate.Reasons = append(candidate.Reasons, "description")
+	}
+	if item.Geohash != "" && other.Geohash != "" {
+		prefix := 0
+		for prefix < len(item.Geohash) && prefix < len(other.Geohash) && item.Geohash[prefix] == other.Geohash[prefix] {
+			prefix++
+		}
+		switch {
+		case prefix >= 5:
+			score += 2
+			candidate.Reasons = append(candidate.Reasons, "location")
+		case prefix == 4:
+			score++
+			candidate.Reasons = append(candidate.Reasons, "location")
+		}
+	}
+	if foundAt.Sub(lostAt) <= 72*time.Hour {
+		score++
+		candidate.Reasons = append(candidate.Reasons, "time")
+	}
+	candidate.Score = math.Round(score*100) / 100
+	return candidate, true
+}
+
+// lostFoundCandidates ranks the pool against an item, best first, leaving out items already
+// resolved against it
+func lostFoundCandidates(item LostFoundItem, pool []LostFoundItem, resolved map[string]bool) []LostFoundCandidate {
+	candidates := []LostFoundCandidate{}
+	for _, other := range pool {
+		if other.ItemID == item.ItemID || other.Kind == item.Kind || other.Category != item.Category || other.Status != lostFoundOpen || resolved[other.ItemID] {
+			continue
+		}
+		if candidate, ok := scoreLostFoundCandidate(item, other); ok {
+			candidates = append(candidates, candidate)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].Score != candidates[j].Score {
+			return candidates[i].Score > candidates[j].Score
+		}
+		return candidates[i].Item.ItemID < candidates[j].Item.ItemID
+	})
+	if len(candidates) > maxLostFoundCandidates {
+		candidates = candidates[:maxLostFoundCandidates]
+	}
+	return candidates
+}
+
+// ReportLostFoundItem anchors a lost or found item. The chaincode checks a reporter DID exists.
+func (ledgerService) ReportLostFoundItem(ctx context.Context, itemID string, req LostFoundItemRequest) (*TransactionResult, error) {
+	if errs := req.Validate(); len(errs) > 0 {
+		return nil, errs
+	}
+	geohash := ""
+	if req.Latitude != nil {
+		geohash = encodeGeohash(*req.Latitude, *req.Longitude, ledgerGeohashPrecision)
+	}
+	return submitTransaction(ctx, "ReportLostFoundItem", itemID, req.Kind, req.Category, strings.TrimSpace(req.Description),
+		strings.TrimSpace(req.Color), strings.TrimSpace(req.Brand), strings.TrimSpace(req.LocationName), geohash, req.OccurredAt, req.ReporterDID, req.Actor)
+}
+
+// GetLostFoundItem reads an item. Items are shared between organizations so that an item lost in
+// one district can be matched to one handed in at another.
+func (s ledgerService) GetLostFoundItem(ctx context.Context, itemID string) (LostFoundItem, error) {
+	result, err := s.readDocument(ctx, "ReadLostFoundItem", itemID)
+	if err != nil {
+		return LostFoundItem{}, err
+	}
+	item, err := decodeDocument[LostFoundItem](result, "lost and found item")
+	if err != nil {
+		return LostFoundItem{}, err
+	}
+	return item.visibleTo(tenantFromContext(ctx)), nil
+}
+
+func (ledgerService) queryLostFoundItems(ctx context.Context, kind, category, status string) ([]LostFoundItem, error) {
+	result, err := evaluateTransaction(ctx, "QueryLostFoundItems", kind, category, status)
+	if err != nil {
+		return nil, err
+	}
+	return decodeDocument[[]LostFoundItem](result, "lost and found item")
+}
+
+// SearchLostFoundItems returns the items matching a search, latest reported first
+func (s ledgerService) SearchLostFoundItems(ctx context.Context, req LostFoundSearchRequest) ([]LostFoundItem, error) {
+	if errs := req.Validate(); len(errs) > 0 {
+		return nil, errs
+	}
+	items, err := s.queryLostFoundItems(ctx, req.Kind, req.Category, req.Status)
+	if err != nil {
+		return nil, err
+	}
+	t := tenantFromContext(ctx)
+	found := []LostFoundItem{}
+	for _, item := range items {
+		if matchesLostFoundSearch(item, req) {
+			found = append(found, item.visibleTo(t))
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].ReportedAt > found[j].ReportedAt })
+	return found, nil
+}
+
+// ListLostFoundMatches returns the match resolutions involving an item, latest first
+func (s ledgerService) ListLostFoundMatches(ctx context.Context, itemID string) ([]LostFoundMatch, error) {
+	if _, err := s.GetLostFoundItem(ctx, itemID); err != nil {
+		return nil, err
+	}
+	result, err := evaluateTransaction(ctx, "GetLostFoundMatchesByItem", itemID)
+	if err != nil {
+		return nil, err
+	}
+	matches, err := decodeDocument[[]LostFoundMatch](result, "lost and found match")
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].ResolvedAt > matches[j].ResolvedAt })
+	return matches, nil
+}
+
+// LostFoundCandidates proposes open items of the other kind and the same category that may be the
+// one reported, leaving out pairs already resolved
+func (s ledgerService) LostFoundCandidates(ctx context.Context, itemID string) ([]LostFoundCandidate, error) {
+	item, err := s.GetLostFoundItem(ctx, itemID)
+	if err != nil {
+		return nil, err
+	}
+	if item.Status != lostFoundOpen {
+		return []LostFoundCandidate{}, nil
+	}
+	other := lostFoundFound
+	if item.Kind == lostFoundFound {
+		other = lostFoundLost
+	}
+	pool, err := s.queryLostFoundItems(ctx, other, item.Category, lostFoundOpen)
+	if err != nil {
+		return nil, err
+	}
+	matches, err := s.ListLostFoundMatches(ctx, itemID)
+	if err != nil {
+		return nil, err
+	}
+	resolved := map[string]bool{}
+	for _, match := range matches {
+		resolved[match.LostItemID], resolved[match.FoundItemID] = true, true
+	}
+	t := tenantFromContext(ctx)
+	candidates := lostFoundCandidates(item, pool, resolved)
+	for i := range candidates {
+		candidates[i].Item = candidates[i].Item.visibleTo(t)
+	}
+	return candidates, nil
+}
+
+// ResolveLostFoundMatch records a match resolution; the chaincode only lets an organization that
+// reported one of the two items
+func (ledgerService) ResolveLostFoundMatch(ctx context.Context, matchID string, req LostFoundMatchRequest) (*TransactionResult, error) {
+	if errs := req.Validate(); len(errs) > 0 {
+		return nil, errs
+	}
+	result, err := submitTransaction(ctx, "ResolveLostFoundMatch", matchID, req.LostItemID, req.FoundItemID, req.Resolution, strings.TrimSpace(req.Note), req.Actor)
+	if err != nil {
+		return nil, err
+	}
+	invalidateDocuments(ctx, req.LostItemID, req.FoundItemID)
+	return result, nil
+}
+
+func (s ledgerService) GetLostFoundMatch(ctx context.Context, matchID string) (LostFoundMatch, error) {
+	result, err := s.readDocument(ctx, "ReadLostFoundMatch", matchID)
+	if err != nil {
+		return LostFoundMatch{}, err
+	}
+	return decodeDocument[LostFoundMatch](result, "lost and found match")
+}
+
+// CloseLostFoundItem withdraws an open item; the chaincode only lets the reporting organization
+func (ledgerService) CloseLostFoundItem(ctx context.Context, itemID string, req CloseLostFoundItemRequest) (*TransactionResult, error) {
+	if err := validateMutation(itemID, req); err != nil {
+		return nil, err
+	}
+	return submitAndInvalidate(ctx, itemID, "CloseLostFoundItem", itemID, strings.TrimSpace(req.Reason), req.Actor)
+}
+
+func reportLostFoundItem(c *gin.Context) {
+	var req LostFoundItemRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+	itemID := newLostFoundID("LF", time.Now())
+	setAuditTarget(c, itemID)
+
+	result, err := ledger.ReportLostFoundItem(c.Request.Context(), itemID, req)
+	if err != nil {
+		respondServiceError(c, "Failed to report item", err)
+		return
+	}
+	respondCommitted(c, http.StatusCreated, gin.H{
+		"message": "Item reported successfully",
+		"itemID":  itemID,
+		"kind":    req.Kind,
+	}, result)
+}
+
+func searchLostFoundItems(c *gin.Context) {
+	var req LostFoundSearchRequest
+	if !bindQuery(c, &req) {
+		return
+	}
+	items, err := ledger.SearchLostFoundItems(c.Request.Context(), req)
+	if err != nil {
+		respondServiceError(c, "Failed to search items", err)
+		return
+	}
+	respondData(c, http.StatusOK, items)
+}
+
+func getLostFoundItem(c *gin.Context) {
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	item, err := ledger.GetLostFoundItem(c.Request.Context(), id)
+	if err != nil {
+		respondServiceError(c, "Failed to read item", err)
+		return
+	}
+	respondTagged(c, item, item.TxID)
+}
+
+func listLostFoundCandidates(c *gin.Context) {
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	candidates, err := ledger.LostFoundCandidates(c.Request.Context(), id)
+	if err != nil {
+		respondServiceError(c, "Failed to find candidate matches", err)
+		return
+	}
+	respondData(c, http.StatusOK, candidates)
+}
+
+func listLostFoundMatches(c *gin.Context) {
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	matches, err := ledger.ListLostFoundMatches(c.Request.Context(), id)
+	if err != nil {
+		respondServiceError(c, "Failed to list matches", err)
+		return
+	}
+	respondData(c, http.StatusOK, matches)
+}
+
+func closeLostFoundItem(c *gin.Context) {
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	var req CloseLostFoundItemRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+	setAuditTarget(c, id)
+	result, err := ledger.CloseLostFoundItem(c.Request.Context(), id, req)
+	if err != nil {
+		respondServiceError(c, "Failed to close item", err)
+		return
+	}
+	respondCommitted(c, http.StatusOK, gin.H{"message": "Item closed successfully", "itemID": id}, result)
+}
+
+func resolveLostFoundMatch(c *gin.Context) {
+	var req LostFoundMatchRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+	matchID := newLostFoundID("LFM", time.Now())
+	setAuditTarget(c, matchID)
+
+	result, err := ledger.ResolveLostFoundMatch(c.Request.Context(), matchID, req)
+	if err != nil {
+		respondServiceError(c, "Failed to resolve match", err)
+		return
+	}
+	respondCommitted(c, http.StatusCreated, gin.H{
+		"message":     "Match resolved successfully",
+		"matchID":     matchID,
+		"lostItemID":  req.LostItemID,
+		"foundItemID": req.FoundItemID,
+		"resolution":  req.Resolution,
+	}, result)
+}
+
+func getLostFoundMatch(c *gin.Context) {
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	match, err := ledger.GetLostFoundMatch(c.Request.Context(), id)
+	if err != nil {
+		respondServiceError(c, "Failed to read match", err)
+		return
+	}
+	respondTagged(c, match, match.TxID)
+}
+
+func respondPhotoTooLarge(c *gin.Context) {
+	respondError(c, http.StatusRequestEntityTooLarge, errCodePayloadTooLarge, fmt.Sprintf("Photo must not exceed %d bytes", maxLostFoundPhotoBytes))
+}
+
+// uploadLostFoundPhoto stores a photo of an item in the evidence store and anchors its SHA-256.
+// The actor field must precede the file part. Photos are checked and scanned like evidence, but
+// are small enough to hold in memory, so they are stored under their hash.
+func uploadLostFoundPhoto(c *gin.Context) {
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	setAuditTarget(c, id)
+	limit := int64(maxLostFoundPhotoBytes + uploadFormOverhead)
+	if c.Request.ContentLength > limit {
+		respondPhotoTooLarge(c)
+		return
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		respondValidationErrors(c, ValidationErrors{{Field: "body", Message: "must be multipart/form-data"}})
+		return
+	}
+	var req LostFoundPhotoRequest
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			respondValidationErrors(c, ValidationErrors{{Field: uploadFileField, Message: "is required"}})
+			return
+		}
+		if err != nil {
+			if isUploadTooLarge(err) {
+				respondPhotoTooLarge(c)
+				return
+			}
+			respondValidationErrors(c, ValidationErrors{{Field: "body", Message: err.Error()}})
+			return
+		}
+		if part.FormName() != uploadFileField {
+			value, err := io.ReadAll(io.LimitReader(part, maxFormFieldBytes))
+			part.Close()
+			if err != nil {
+				respondValidationErrors(c, ValidationErrors{{Field: part.FormName(), Message: err.Error()}})
+				return
+			}
+			if part.FormName() == "actor" {
+				req.Actor = string(value)
+			}
+			continue
+		}
+
+		defer part.Close()
+		v := fieldValidator{errors: req.Validate()}
+		mediaType := partMediaType(part.Header.Get("Content-Type"))
+		v.oneOf("mediaType", mediaType, lostFoundPhotoTypes())
+		if len(v.errors) > 0 {
+			respondValidationErrors(c, v.errors)
+			return
+		}
+		photo, err := io.ReadAll(&uploadLimitReader{reader: part, limit: maxLostFoundPhotoBytes})
+		if err != nil {
+			if isUploadTooLarge(err) {
+				respondPhotoTooLarge(c)
+			} else {
+				respondValidationErrors(c, ValidationErrors{{Field: uploadFileField, Message: err.Error()}})
+			}
+			return
+		}
+		if len(photo) == 0 {
+			respondValidationErrors(c, ValidationErrors{{Field: uploadFileField, Message: "must not be empty"}})
+			return
+		}
+		if !slices.Contains(sniffMediaTypes(photo[:min(len(photo), sniffLength)]), mediaType) {
+			v.add(uploadFileField, "content does not match the declared media type %s", mediaType)
+			respondValidationErrors(c, v.errors)
+			return
+		}
+		if uploads.scanner != nil {
+			spool := scanEvidence(c, bytes.NewReader(photo))
+			if spool == nil {
+				return
+			}
+			spool.Close()
+			os.Remove(spool.Name())
+		}
+		storeAndAnchorLostFoundPhoto(c, id, req.Actor, mediaType, photo)
+		return
+	}
+}
+
+// storeAndAnchorLostFoundPhoto writes the photo to the evidence store and submits AddLostFoundPhoto
+// with its hash, removing the file again if the ledger refuses it
+func storeAndAnchorLostFoundPhoto(c *gin.Context, itemID, actor, mediaType string, photo []byte) {
+	ctx := c.Request.Context()
+	item, err := ledger.GetLostFoundItem(ctx, itemID)
+	if err != nil {
+		respondServiceError(c, "Failed to read item", err)
+		return
+	}
+	if item.OwnerOrg != callerOrg(ctx) {
+		respondError(c, http.StatusForbidden, errCodeAccessDenied, fmt.Sprintf("Only %s can add photos of item %s", item.OwnerOrg, itemID))
+		return
+	}
+	sum := sha256.Sum256(photo)
+	photoHash := hex.EncodeToString(sum[:])
+	// The stored file may already be anchored, so it must not be deleted on the way out
+	if slices.ContainsFunc(item.Photos, func(p LostFoundPhoto) bool { return p.PhotoHash == photoHash }) {
+		respondError(c, http.StatusConflict, errCodeAlreadyExists, fmt.Sprintf("The photo %s of item %s already exists", photoHash, itemID))
+		return
+	}
+	if len(item.Photos) >= maxLostFoundPhotos {
+		respondValidationErrors(c, ValidationErrors{{Field: uploadFileField, Message: fmt.Sprintf("item %s already has %d photos", itemID, maxLostFoundPhotos)}})
+		return
+	}
+
+	key := lostFoundPhotoKey(itemID, photoHash)
+	if err := evidenceStore.Put(ctx, key, bytes.NewReader(photo), int64(len(photo)), mediaType); err != nil {
+		logWithContext(ctx, "Failed to store photo %s: %v", key, err)
+		respondError(c, http.StatusInternalServerError, errCodeInternal, "Failed to store photo")
+		return
+	}
+	result, err := submitAndInvalidate(ctx, itemID, "AddLostFoundPhoto", itemID, photoHash, mediaType, actor)
+	if err != nil {
+		if delErr := evidenceStore.Delete(ctx, key); delErr != nil {
+			logWithContext(ctx, "Failed to remove orphaned photo %s: %v", key, delErr)
+		}
+		respondFabricError(c, "Failed to add photo", err)
+		return
+	}
+	respondCommitted(c, http.StatusCreated, gin.H{
+		"message":    "Photo added successfully",
+		"itemID":     itemID,
+		"photoHash":  photoHash,
+		"mediaType":  mediaType,
+		"size":       len(photo),
+		"storageKey": key,
+	}, result)
+}
+
+// downloadLostFoundPhoto serves a photo only when it still matches the hash anchored on the item
+func downloadLostFoundPhoto(c *gin.Context) {
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	photoHash := c.Param("hash")
+	var v fieldValidator
+	if v.sha256("hash", photoHash); len(v.errors) > 0 {
+		respondValidationErrors(c, v.errors)
+		return
+	}
+	ctx := c.Request.Context()
+	item, err := ledger.GetLostFoundItem(ctx, id)
+	if err != nil {
+		respondServiceError(c, "Failed to read item", err)
+		return
+	}
+	index := slices.IndexFunc(item.Photos, func(p LostFoundPhoto) bool { return p.PhotoHash == photoHash })
+	if index < 0 {
+		respondError(c, http.StatusNotFound, errCodeNotFound, fmt.Sprintf("Item %s has no photo %s", id, photoHash))
+		return
+	}
+
+	key := lostFoundPhotoKey(id, photoHash)
+	body, err := evidenceStore.Get(ctx, key)
+	if errors.Is(err, errObjectNotFound) {
+		respondError(c, http.StatusNotFound, errCodeNotFound, fmt.Sprintf("No stored file for photo %s", photoHash))
+		return
+	}
+	if err != nil {
+		logWithContext(ctx, "Failed to read photo %s: %v", key, err)
+		respondError(c, http.StatusServiceUnavailable, errCodeUnavailable, "Failed to read photo from storage")
+		return
+	}
+	defer body.Close()
+	photo, err := io.ReadAll(io.LimitReader(body, maxLostFoundPhotoBytes+1))
+	if err != nil {
+		respondError(c, http.StatusServiceUnavailable, errCodeUnavailable, "Failed to read photo from storage")
+		return
+	}
+	if sum := sha256.Sum256(photo); hex.EncodeToString(sum[:]) != photoHash {
+		logWithContext(ctx, "⚠️  Photo %s of item %s does not match its anchored hash", photoHash, id)
+		respondError(c, http.StatusConflict, errCodeEvidenceTampered, "Stored photo does not match the hash anchored on the ledger")
+		return
+	}
+	c.Header("X-Evidence-Hash", photoHash)
+	c.Data(http.StatusOK, item.Photos[index].MediaType, photo)
+}
@@ -0,0 +1,94 @@
+package gateway
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLostFoundCandidates(t *testing.T) {
+	lost := LostFoundItem{
+		ItemID: "LF-1", Kind: lostFoundLost, Category: "bags", Status: lostFoundOpen, Color: "Black", Brand: "Wildcraft",
+		Description: "Black backpack with a laptop and a red water bottle", Geohash: "tsq4xy", OccurredAt: "2025-11-02T10:00:00Z",
+	}
+	pool := []LostFoundItem{
+		{ItemID: "LF-2", Kind: lostFoundFound, Category: "bags", Status: lostFoundOpen, Color: "black", Brand: "wildcraft",
+			Description: "Backpack with laptop", Geohash: "tsq4xz", OccurredAt: "2025-11-02T16:00:00Z"},
+		{ItemID: "LF-3", Kind: lostFoundFound, Category: "bags", Status: lostFoundOpen, Color: "blue",
+			Description: "Blue suitcase", Geohash: "tsr000", OccurredAt: "2025-11-10T09:00:00Z"},
+		// Handed in days before the backpack was lost
+		{ItemID: "LF-4", Kind: lostFoundFound, Category: "bags", Status: lostFoundOpen, Color: "black", Description: "Black backpack", OccurredAt: "2025-10-28T09:00:00Z"},
+		{ItemID: "LF-5", Kind: lostFoundFound, Category: "wallet", Status: lostFoundOpen, Color: "black", OccurredAt: "2025-11-02T11:00:00Z"},
+		{ItemID: "LF-6", Kind: lostFoundFound, Category: "bags", Status: lostFoundReturned, Color: "black", OccurredAt: "2025-11-02T11:00:00Z"},
+		{ItemID: "LF-7", Kind: lostFoundFound, Category: "bags", Status: lostFoundOpen, Color: "black", OccurredAt: "2025-11-02T11:00:00Z"},
+		{ItemID: "LF-8", Kind: lostFoundLost, Category: "bags", Status: lostFoundOpen, Color: "black", OccurredAt: "2025-11-02T11:00:00Z"},
+	}
+
+	candidates := lostFoundCandidates(lost, pool, map[string]bool{"LF-7": true})
+	if len(candidates) != 2 || candidates[0].Item.ItemID != "LF-2" || candidates[1].Item.ItemID != "LF-3" {
+		t.Fatalf("expected LF-2 then LF-3, got %+v", candidates)
+	}
+	if reasons := candidates[0].Reasons; len(reasons) != 5 {
+		t.Errorf("expected color, brand, description, location and time to agree, got %v", reasons)
+	}
+	if candidates[1].Score != 0 || len(candidates[1].Reasons) != 0 {
+		t.Errorf("expected nothing in common with the suitcase, got %+v", candidates[1])
+	}
+
+	// Scored the same from the found item's side
+	found, ok := scoreLostFoundCandidate(pool[0], lost)
+	if !ok || found.Score != candidates[0].Score {
+		t.Errorf("expected the same score both ways, got %+v", found)
+	}
+}
+
+func TestMatchesLostFoundSearch(t *testing.T) {
+	item := LostFoundItem{Description: "Passport in a brown leather cover", LocationName: "Baga beach shack", Geohash: encodeGeohash(15.556, 73.752, ledgerGeohashPrecision), OccurredAt: "2025-11-02T10:00:00Z"}
+	lat, lng, far := 15.55, 73.75, 16.5
+	cases := []struct {
+		req  LostFoundSearchRequest
+		want bool
+	}{
+		{LostFoundSearchRequest{Q: "leather PASSPORT"}, true},
+		{LostFoundSearchRequest{Q: "passport wallet"}, false},
+		{LostFoundSearchRequest{Q: "baga"}, true},
+		{LostFoundSearchRequest{Lat: &lat, Lng: &lng}, true},
+		{LostFoundSearchRequest{Lat: &far, Lng: &lng, RadiusKm: 10}, false},
+		{LostFoundSearchRequest{From: "2025-11-01T00:00:00Z", To: "2025-11-03T00:00:00Z"}, true},
+		{LostFoundSearchRequest{From: "2025-11-03T00:00:00Z"}, false},
+	}
+	for _, c := range cases {
+		if got := matchesLostFoundSearch(item, c.req); got != c.want {
+			t.Errorf("%+v: expected %v", c.req, c.want)
+		}
+	}
+	item.Geohash = ""
+	if matchesLostFoundSearch(item, LostFoundSearchRequest{Lat: &lat, Lng: &lng}) {
+		t.Error("expected an item without a location to be left out of a distance search")
+	}
+}
+
+func TestLostFoundItemRequestValidation(t *testing.T) {
+	lat, lng := 15.55, 73.75
+	valid := LostFoundItemRequest{
+		Kind: lostFoundLost, Category: "documents", Description: "Passport in a brown cover", LocationName: "Baga beach",
+		Latitude: &lat, Longitude: &lng, OccurredAt: "2025-11-02T10:00:00Z", ReporterDID: "did:sih:1", Actor: "help_desk",
+	}
+	if errs := valid.Validate(); len(errs) > 0 {
+		t.Fatalf("unexpected errors %v", errs)
+	}
+	cases := map[string]func(r *LostFoundItemRequest){
+		"kind":        func(r *LostFoundItemRequest) { r.Kind = "stolen" },
+		"category":    func(r *LostFoundItemRequest) { r.Category = "pets" },
+		"description": func(r *LostFoundItemRequest) { r.Description = "  " },
+		"longitude":   func(r *LostFoundItemRequest) { r.Longitude = nil },
+		"occurredAt":  func(r *LostFoundItemRequest) { r.OccurredAt = time.Now().Add(time.Hour).Format(time.RFC3339) },
+		"reporterDID": func(r *LostFoundItemRequest) { r.ReporterDID = "tourist-1" },
+	}
+	for field, change := range cases {
+		req := valid
+		change(&req)
+		if errs := req.Validate(); len(errs) != 1 || errs[0].Field != field {
+			t.Errorf("expected %s rejected, got %v", field, errs)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"log"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultNearbyRadiusKm = 2.0
+	maxNearbyRadiusKm     = 25.0
+)
+
+// MeSummaryRequest names the tourist the app is showing
+type MeSummaryRequest struct {
+	DigitalID string  `form:"digital_id"`
+	RadiusKm  float64 `form:"radius_km"`
+}
+
+func (r MeSummaryRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.digitalID("digital_id", r.DigitalID)
+	if r.RadiusKm < 0 || r.RadiusKm > maxNearbyRadiusKm {
+		v.add("radius_km", "must be between 0 and %d", int(maxNearbyRadiusKm))
+	}
+	return v.errors
+}
+
+// ActiveTrip is the stay a tourist's DID was issued for
+type ActiveTrip struct {
+	StartedAt     string `json:"started_at"`
+	EndsAt        string `json:"ends_at"`
+	DaysRemaining int    `json:"days_remaining"`
+}
+
+// MeSummary is everything the app's home screen shows, in one round trip
+type MeSummary struct {
+	DigitalID     string             `json:"digital_id"`
+	DID           *DIDVerification   `json:"did"`
+	ActiveTrip    *ActiveTrip        `json:"active_trip"`
+	Location      *LiveLocation      `json:"location"`
+	OpenIncidents []IncidentDocument `json:"open_incidents"`
+	SafetyScore   SafetyScore        `json:"safety_score"`
+	NearbyZones   []NearbyZone       `json:"nearby_zones"`
+	// Unavailable names the sections that could not be read; the rest of the summary still stands
+	Unavailable []string `json:"unavailable,omitempty"`
+	GeneratedAt string   `json:"generated_at"`
+}
+
+// Summary reads the tourist's DID, live location and open incidents concurrently, then adds the
+// zones around them and the safety score from the engine
+func (ledgerService) Summary(ctx context.Context, req MeSummaryRequest) (MeSummary, error) {
+	if errs := req.Validate(); len(errs) > 0 {
+		return MeSummary{}, errs
+	}
+	if req.RadiusKm == 0 {
+		req.RadiusKm = defaultNearbyRadiusKm
+	}
+	now := time.Now().UTC()
+	summary := MeSummary{DigitalID: req.DigitalID, OpenIncidents: []IncidentDocument{}, NearbyZones: []NearbyZone{}, GeneratedAt: now.Format(time.RFC3339)}
+
+	var mu sync.Mutex
+	unavailable := func(section string, err error) {
+		logWithContext(ctx, "Summary for %s without %s: %v", req.DigitalID, section, err)
+		mu.Lock()
+		summary.Unavailable = append(summary.Unavailable, section)
+		mu.Unlock()
+	}
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		verdict, err := ledger.VerifyDID(ctx, req.DigitalID)
+		if err != nil {
+			unavailable("did", err)
+			return
+		}
+		summary.DID = &verdict
+		summary.ActiveTrip = activeTrip(verdict, now)
+	}()
+	go func() {
+		defer wg.Done()
+		live, err := locations.store.latest(ctx, req.DigitalID)
+		if err != nil {
+			unavailable("location", err)
+			return
+		}
+		summary.Location = live
+	}()
+	go func() {
+		defer wg.Done()
+		incidents, err := ledger.ListOpenIncidentsBySubject(ctx, req.DigitalID)
+		if err != nil {
+			unavailable("open_incidents", err)
+			return
+		}
+		summary.OpenIncidents = incidents
+	}()
+	wg.Wait()
+
+	if summary.Location != nil && geofence != nil {
+		summary.NearbyZones = geofence.nearby(summary.Location.Latitude, summary.Location.Longitude, req.RadiusKm, "medium", now)
+	}
+	summary.SafetyScore = SafetyScore{DigitalID: req.DigitalID, Score: 100, Level: "safe", Components: []SafetyComponent{}}
+	if summary.Location != nil && safety != nil {
+		score, err := safety.current(ctx, req.DigitalID)
+		if err != nil {
+			unavailable("safety_score", err)
+		} else if score != nil {
+			summary.SafetyScore = *score
+		}
+	}
+	return summary, nil
+}
+
+// activeTrip treats the DID's validity window as the trip, since the ledger records no itinerary
+func activeTrip(verdict DIDVerification, now time.Time) *ActiveTrip {
+	if !verdict.Valid {
+		return nil
+	}
+	expiresAt, err := time.Parse(time.RFC3339, verdict.ExpiresAt)
+	if err != nil {
+		return nil
+	}
+	return &ActiveTrip{
+		StartedAt:     verdict.IssuedAt,
+		EndsAt:        verdict.ExpiresAt,
+		DaysRemaining: int(math.Ceil(expiresAt.Sub(now).Hours() / 24)),
+	}
+}
+
+func getMySummary(c *gin.Context) {
+	var req MeSummaryRequest
+	if !bindQuery(c, &req) {
+		return
+	}
+
+	summary, err := ledger.Summary(c.Request.Context(), req)
+	if err != nil {
+		respondServiceError(c, "Failed to build summary", err)
+		return
+	}
+	if len(summary.Unavailable) > 0 {
+		log.Printf("Partial summary for %s: %v unavailable", req.DigitalID, summary.Unavailable)
+	}
+	respondData(c, http.StatusOK, summary)
+}
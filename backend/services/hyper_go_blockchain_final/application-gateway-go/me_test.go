@@ -0,0 +1,50 @@
+package gateway
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMySummaryParts(t *testing.T) {
+	geofence = newGeofenceIndex()
+	defer func() { geofence = nil }()
+	ctx := context.Background()
+
+	// A restricted cliff the tourist is standing in, a high-risk market about 1.5km east and a
+	// low-risk park that is below the minimum risk
+	cliff := `{"type":"Polygon","coordinates":[[[91.880,25.570],[91.890,25.570],[91.890,25.580],[91.880,25.580],[91.880,25.570]]]}`
+	market := `{"type":"Polygon","coordinates":[[[91.900,25.570],[91.910,25.570],[91.910,25.580],[91.900,25.580],[91.900,25.570]]]}`
+	park := `{"type":"Polygon","coordinates":[[[91.870,25.570],[91.879,25.570],[91.879,25.580],[91.870,25.580],[91.870,25.570]]]}`
+	for _, doc := range []ZoneDocument{
+		{ZoneID: "cliff", Name: "Cliff edge", Geometry: cliff, RiskLevel: "restricted"},
+		{ZoneID: "market", Name: "Night market", Geometry: market, RiskLevel: "high"},
+		{ZoneID: "park", Name: "Park", Geometry: park, RiskLevel: "low"},
+	} {
+		geofenceFromEvent(ctx, zoneEvent(t, "CreateZone", doc))
+	}
+
+	now := time.Date(2025, 7, 1, 12, 0, 0, 0, time.UTC)
+	zones := geofence.nearby(25.575, 91.885, 2, "medium", now)
+	if len(zones) != 2 || zones[0].ZoneID != "cliff" || !zones[0].Inside || zones[1].ZoneID != "market" || zones[1].Inside {
+		t.Fatalf("expected the cliff then the market, got %+v", zones)
+	}
+	if zones[1].DistanceKm < 1.4 || zones[1].DistanceKm > 1.6 {
+		t.Errorf("expected the market about 1.5km away, got %v", zones[1].DistanceKm)
+	}
+	if far := geofence.nearby(25.575, 91.885, 1, "medium", now); len(far) != 1 {
+		t.Errorf("expected the market outside a 1km radius, got %+v", far)
+	}
+
+	trip := activeTrip(DIDVerification{Valid: true, IssuedAt: "2025-06-28T00:00:00Z", ExpiresAt: "2025-07-03T06:00:00Z"}, now)
+	if trip == nil || trip.DaysRemaining != 2 {
+		t.Errorf("expected 2 days remaining, got %+v", trip)
+	}
+	if trip := activeTrip(DIDVerification{Valid: false, ExpiresAt: "2025-07-03T06:00:00Z"}, now); trip != nil {
+		t.Errorf("expected no trip for an invalid DID, got %+v", trip)
+	}
+
+	if errs := (MeSummaryRequest{DigitalID: "did:tourist:1", RadiusKm: 30}).Validate(); len(errs) != 1 {
+		t.Errorf("expected the radius rejected, got %v", errs)
+	}
+}
@@ -0,0 +1,937 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	missingCasePrefix = "sih:missing:case:"
+
+	caseStageOpened  = "opened"
+	caseStageRevised = "area_revised"
+	caseStageSighted = "sighted"
+	caseStageFound   = "found"
+	caseStageClosed  = "closed"
+
+	// Sectors are drawn as polygons of this many vertices
+	searchSectorVertices = 24
+	minSearchSectorKm    = 0.5
+	maxSearchSectors     = 8
+	maxCaseUnits         = 10
+	// Zone entries and check-outs older than this say little about where the tourist is now
+	searchEvidenceWindow = 48 * time.Hour
+)
+
+// caseTransitions lists the stages a case may move to from each stage
+var caseTransitions = map[string][]string{
+	caseStageOpened:  {caseStageRevised, caseStageSighted, caseStageFound, caseStageClosed},
+	caseStageRevised: {caseStageRevised, caseStageSighted, caseStageFound, caseStageClosed},
+	caseStageSighted: {caseStageRevised, caseStageSighted, caseStageFound, caseStageClosed},
+	caseStageFound:   {caseStageClosed},
+}
+
+// LastSeen is the latest position known for a missing tourist. Source is ping, sighting or stay.
+type LastSeen struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Accuracy  float64 `json:"accuracy"`
+	At        string  `json:"at"`
+	Source    string  `json:"source"`
+}
+
+// Sighting is a report of the tourist made while the case is open
+type Sighting struct {
+	Latitude   float64 `json:"latitude"`
+	Longitude  float64 `json:"longitude"`
+	Accuracy   float64 `json:"accuracy"`
+	SeenAt     string  `json:"seen_at"`
+	ReportedBy string  `json:"reported_by"`
+}
+
+// MissingPersonEvidence is what the gateway knew about the tourist when the search area was last
+// drawn. Each source is empty when its feature is disabled or holds nothing for the tourist.
+type MissingPersonEvidence struct {
+	LastSeen    *LastSeen       `json:"last_seen,omitempty"`
+	Trail       []TrailPoint    `json:"trail"`
+	Itinerary   *Itinerary      `json:"itinerary,omitempty"`
+	Stays       []StayDocument  `json:"stays"`
+	ZoneEntries []GeofenceEvent `json:"zone_entries"`
+	Sightings   []Sighting      `json:"sightings"`
+	GatheredAt  string          `json:"gathered_at"`
+}
+
+// SearchSector is one part of the search area. Rank 1 is searched first; Score, from 0 to 1, is
+// how strongly the evidence points at it.
+type SearchSector struct {
+	Rank      int             `json:"rank"`
+	Source    string          `json:"source"`
+	Label     string          `json:"label"`
+	Latitude  float64         `json:"latitude"`
+	Longitude float64         `json:"longitude"`
+	RadiusKm  float64         `json:"radius_km"`
+	Score     float64         `json:"score"`
+	Geometry  GeoJSONGeometry `json:"geometry"`
+}
+
+// CaseUnit is a police unit notified about the case, with the sector it was asked to cover
+type CaseUnit struct {
+	UnitID     string  `json:"unit_id"`
+	Name       string  `json:"name"`
+	Zone       string  `json:"zone"`
+	SectorRank int     `json:"sector_rank"`
+	DistanceKm float64 `json:"distance_km"`
+	State      string  `json:"state"`
+	NotifiedAt string  `json:"notified_at"`
+}
+
+// CaseStage is one anchored step of a case. Digest is the SHA-256 of the case as it stood, which
+// the ledger holds under AnchorID.
+type CaseStage struct {
+	Sequence int    `json:"sequence"`
+	Stage    string `json:"stage"`
+	Actor    string `json:"actor"`
+	NoteHash string `json:"note_hash,omitempty"`
+	At       string `json:"at"`
+	Digest   string `json:"digest"`
+	AnchorID string `json:"anchor_id"`
+	TxID     string `json:"tx_id,omitempty"`
+}
+
+// MissingPersonCase tracks the search for a tourist reported missing, from the incident being filed
+// to the tourist being found or the search being called off. SearchArea is the convex hull of the
+// sectors.
+type MissingPersonCase struct {
+	IncidentID string                `json:"incident_id"`
+	DigitalID  string                `json:"digital_id"`
+	Stage      string                `json:"stage"`
+	OpenedAt   string                `json:"opened_at"`
+	UpdatedAt  string                `json:"updated_at"`
+	Evidence   MissingPersonEvidence `json:"evidence"`
+	SearchArea *GeoJSONGeometry      `json:"search_area,omitempty"`
+	Sectors    []SearchSector        `json:"sectors"`
+	Units      []CaseUnit            `json:"units"`
+	Stages     []CaseStage           `json:"stages"`
+}
+
+// CaseAnchor is a case stage digest as the chaincode stores it
+type CaseAnchor struct {
+	AnchorID   string `json:"anchor_id"`
+	IncidentID string `json:"incident_id"`
+	Sequence   int    `json:"sequence"`
+	Stage      string `json:"stage"`
+	Digest     string `json:"digest"`
+	AnchoredBy string `json:"anchored_by"`
+	AnchoredAt string `json:"anchored_at"`
+	OwnerOrg   string `json:"owner_org,omitempty"`
+	TxID       string `json:"tx_id"`
+}
+
+// OpenMissingPersonRequest opens the case of a missing person incident filed before cases were
+// tracked, or whose case failed to open
+type OpenMissingPersonRequest struct {
+	IncidentID string `json:"incidentID" binding:"required"`
+	Actor      string `json:"actor" binding:"required"`
+}
+
+func (r OpenMissingPersonRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.identifier("incidentID", r.IncidentID)
+	v.identifier("actor", r.Actor)
+	return v.errors
+}
+
+// CaseStageRequest moves a case on. A sighting needs the position and time the tourist was seen,
+// and redraws the search area around it, as area_revised does with the evidence gathered since.
+// NoteHash is the SHA-256 of the officer's note, which stays off the ledger.
+type CaseStageRequest struct {
+	Stage     string   `json:"stage" binding:"required"`
+	Actor     string   `json:"actor" binding:"required"`
+	NoteHash  string   `json:"noteHash"`
+	Latitude  *float64 `json:"latitude"`
+	Longitude *float64 `json:"longitude"`
+	Accuracy  float64  `json:"accuracy"`
+	SeenAt    string   `json:"seenAt"`
+}
+
+func (r CaseStageRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.oneOf("stage", r.Stage, []string{caseStageRevised, caseStageSighted, caseStageFound, caseStageClosed})
+	v.identifier("actor", r.Actor)
+	if r.NoteHash != "" {
+		v.sha256("noteHash", r.NoteHash)
+	}
+	if r.Stage != caseStageSighted {
+		return v.errors
+	}
+	if r.Latitude == nil || *r.Latitude < -90 || *r.Latitude > 90 {
+		v.add("latitude", "must be between -90 and 90")
+	}
+	if r.Longitude == nil || *r.Longitude < -180 || *r.Longitude > 180 {
+		v.add("longitude", "must be between -180 and 180")
+	}
+	if r.Accuracy < 0 {
+		v.add("accuracy", "must not be negative")
+	}
+	if t, ok := v.rfc3339("seenAt", r.SeenAt); ok && t.After(time.Now().Add(maxLocationPingClockSkew)) {
+		v.add("seenAt", "must not be in the future")
+	}
+	return v.errors
+}
+
+// missingPersonStore keeps cases. It uses Redis when the document cache is configured, so any
+// gateway instance can move a case on.
+type missingPersonStore interface {
+	// create stores a new case, reporting false if the incident already has one
+	create(ctx context.Context, c MissingPersonCase) (bool, error)
+	load(ctx context.Context, incidentID string) (*MissingPersonCase, error)
+	save(ctx context.Context, c MissingPersonCase) error
+}
+
+type missingPersonService struct {
+	store missingPersonStore
+	// walkKmh is how far a tourist on foot is assumed to get per hour since they were last seen
+	walkKmh     float64
+	maxRadiusKm float64
+	// unitRadiusKm is how far from a sector a unit may be when no unit is inside it
+	unitRadiusKm float64
+}
+
+var missingPersons *missingPersonService
+
+// initMissingPersons runs after initLocation and initStays, whose data the cases gather
+func initMissingPersons() {
+	var store missingPersonStore = newMemoryMissingPersonStore()
+	backend := "memory"
+	if documentCache != nil {
+		store, backend = redisMissingPersonStore{documentCache, getEnvDuration("MISSING_PERSON_CASE_TTL", 365*24*time.Hour)}, "Redis"
+	}
+	missingPersons = &missingPersonService{
+		store:        store,
+		walkKmh:      getEnvFloat("MISSING_PERSON_WALK_KMH", 4),
+		maxRadiusKm:  getEnvFloat("MISSING_PERSON_MAX_RADIUS_KM", 20),
+		unitRadiusKm: getEnvFloat("MISSING_PERSON_UNIT_RADIUS_KM", 25),
+	}
+	log.Printf("🔎 Missing person cases kept in %s", backend)
+}
+
+// open starts the case of a missing person incident: it gathers the evidence, draws the search
+// area, anchors the opening and notifies the units covering the area. A case whose opening failed
+// to anchor is anchored and its units notified on the next call; a case already open is returned
+// as it is, with opened false.
+func (s *missingPersonService) open(ctx context.Context, incidentID, digitalID, actor string) (*MissingPersonCase, bool, error) {
+	existing, err := s.store.load(ctx, incidentID)
+	if err != nil {
+		return nil, false, err
+	}
+	if existing != nil && existing.Stages[0].TxID != "" {
+		return existing, false, nil
+	}
+
+	c := existing
+	if c == nil {
+		now := time.Now().UTC()
+		c = &MissingPersonCase{IncidentID: incidentID, DigitalID: digitalID, Stage: caseStageOpened, OpenedAt: now.Format(time.RFC3339)}
+		s.redraw(ctx, c, nil, now)
+		s.stage(c, caseStageOpened, actor, "", now)
+		created, err := s.store.create(ctx, *c)
+		if err != nil {
+			return nil, false, err
+		}
+		if !created {
+			return s.open(ctx, incidentID, digitalID, actor)
+		}
+	}
+	if err := s.anchor(ctx, c); err != nil {
+		return nil, false, err
+	}
+	s.notifyUnits(ctx, c, fmt.Sprintf("Missing person search for %s", c.DigitalID))
+	return c, true, s.store.save(ctx, *c)
+}
+
+// advance moves a case to the requested stage. Found and closed also move the incident on, to
+// resolved and closed, and stand down the units searching.
+func (s *missingPersonService) advance(ctx context.Context, incidentID string, req CaseStageRequest) (*MissingPersonCase, error) {
+	if errs := req.Validate(); len(errs) > 0 {
+		return nil, errs
+	}
+	c, err := s.store.load(ctx, incidentID)
+	if err != nil || c == nil {
+		return c, err
+	}
+	if c.Stages[len(c.Stages)-1].TxID == "" {
+		return nil, ValidationErrors{{Field: "stage", Message: "cannot be set until the case is opened on the ledger"}}
+	}
+	allowed := false
+	for _, next := range caseTransitions[c.Stage] {
+		allowed = allowed || next == req.Stage
+	}
+	if !allowed {
+		return nil, ValidationErrors{{Field: "stage", Message: fmt.Sprintf("cannot follow %s", c.Stage)}}
+	}
+
+	now := time.Now().UTC()
+	switch req.Stage {
+	case caseStageSighted:
+		sighting := Sighting{Latitude: *req.Latitude, Longitude: *req.Longitude, Accuracy: req.Accuracy, SeenAt: ledgerTimestamp(req.SeenAt), ReportedBy: req.Actor}
+		s.redraw(ctx, c, append(c.Evidence.Sightings, sighting), now)
+	case caseStageRevised:
+		s.redraw(ctx, c, c.Evidence.Sightings, now)
+	}
+	s.stage(c, req.Stage, req.Actor, req.NoteHash, now)
+	if err := s.anchor(ctx, c); err != nil {
+		return nil, err
+	}
+	if err := s.store.save(ctx, *c); err != nil {
+		return nil, err
+	}
+
+	switch req.Stage {
+	case caseStageSighted, caseStageRevised:
+		s.notifyUnits(ctx, c, fmt.Sprintf("Missing person search for %s updated", c.DigitalID))
+		if err := s.store.save(ctx, *c); err != nil {
+			return nil, err
+		}
+	case caseStageFound:
+		s.updateIncident(ctx, c, "resolved", req.Actor)
+		s.standDown(ctx, c, fmt.Sprintf("Missing person %s found, search stood down", c.DigitalID))
+	case caseStageClosed:
+		s.updateIncident(ctx, c, "closed", req.Actor)
+		if c.Stages[len(c.Stages)-2].Stage != caseStageFound {
+			s.standDown(ctx, c, fmt.Sprintf("Missing person search for %s called off", c.DigitalID))
+		}
+	}
+	return c, nil
+}
+
+// redraw gathers the evidence again and replaces the case's search area
+func (s *missingPersonService) redraw(ctx context.Context, c *MissingPersonCase, sightings []Sighting, now time.Time) {
+	c.Evidence = s.gather(ctx, c.DigitalID, sightings, now)
+	c.Sectors = s.plan(c.Evidence, now)
+	c.SearchArea = searchArea(c.Sectors)
+}
+
+// stage appends a stage to the case and computes its digest. The digest covers the case without
+// the stages' transaction IDs, which are only known once the digest is anchored.
+func (s *missingPersonService) stage(c *MissingPersonCase, stage, actor, noteHash string, now time.Time) {
+	sequence := len(c.Stages) + 1
+	c.Stage, c.UpdatedAt = stage, now.Format(time.RFC3339)
+	entry := CaseStage{
+		Sequence: sequence,
+		Stage:    stage,
+		Actor:    actor,
+		NoteHash: noteHash,
+		At:       c.UpdatedAt,
+		AnchorID: "CASE:" + c.IncidentID + ":" + strconv.Itoa(sequence),
+	}
+	snapshot := *c
+	snapshot.Stages = make([]CaseStage, 0, sequence)
+	for _, previous := range append(c.Stages, entry) {
+		previous.TxID = ""
+		snapshot.Stages = append(snapshot.Stages, previous)
+	}
+	data, _ := json.Marshal(snapshot)
+	sum := sha256.Sum256(data)
+	entry.Digest = hex.EncodeToString(sum[:])
+	c.Stages = append(c.Stages, entry)
+}
+
+// anchor records the case's latest stage on the ledger
+func (s *missingPersonService) anchor(ctx context.Context, c *MissingPersonCase) error {
+	entry := &c.Stages[len(c.Stages)-1]
+	result, err := submitTransaction(ctx, "AnchorCaseStage", entry.AnchorID, c.IncidentID, strconv.Itoa(entry.Sequence), entry.Stage, entry.Digest, entry.Actor)
+	if err != nil {
+		return err
+	}
+	entry.TxID = result.TxID
+	return nil
+}
+
+// gather collects what the gateway holds about the tourist's movements: their live location and
+// trail, declared itinerary, hotel stays and recent zone entries
+func (s *missingPersonService) gather(ctx context.Context, digitalID string, sightings []Sighting, now time.Time) MissingPersonEvidence {
+	e := MissingPersonEvidence{Trail: []TrailPoint{}, Stays: []StayDocument{}, ZoneEntries: []GeofenceEvent{}, Sightings: sightings, GatheredAt: now.Format(time.RFC3339)}
+	if e.Sightings == nil {
+		e.Sightings = []Sighting{}
+	}
+	seen := func(candidate LastSeen) {
+		if e.LastSeen == nil || candidate.At > e.LastSeen.At {
+			e.LastSeen = &candidate
+		}
+	}
+
+	if locations != nil {
+		if live, err := locations.store.latest(ctx, digitalID); err != nil {
+			logWithContext(ctx, "🔎 Failed to read the live location of %s: %v", digitalID, err)
+		} else if live != nil {
+			seen(LastSeen{Latitude: live.Latitude, Longitude: live.Longitude, Accuracy: live.Accuracy, At: live.RecordedAt, Source: "ping"})
+		}
+		if trail, err := locations.Trail(ctx, digitalID); err != nil {
+			logWithContext(ctx, "🔎 Failed to read the trail of %s: %v", digitalID, err)
+		} else {
+			e.Trail = trail
+		}
+	}
+	if anomalies != nil {
+		if itinerary, err := anomalies.itineraries.get(ctx, digitalID); err != nil {
+			logWithContext(ctx, "🔎 Failed to read the itinerary of %s: %v", digitalID, err)
+		} else {
+			e.Itinerary = itinerary
+		}
+	}
+	if stays, err := ledger.ListStays(ctx, digitalID); err != nil {
+		logWithContext(ctx, "🔎 Failed to list the stays of %s: %v", digitalID, err)
+	} else {
+		e.Stays = stays
+	}
+	if zoneTracker != nil {
+		if presence, err := zoneTracker.store.load(ctx, digitalID); err != nil {
+			logWithContext(ctx, "🔎 Failed to read the zone entries of %s: %v", digitalID, err)
+		} else if presence.Entries != nil {
+			e.ZoneEntries = presence.Entries
+		}
+	}
+
+	for _, sighting := range e.Sightings {
+		seen(LastSeen{Latitude: sighting.Latitude, Longitude: sighting.Longitude, Accuracy: sighting.Accuracy, At: sighting.SeenAt, Source: "sighting"})
+	}
+	// A tourist who checked in after their last ping and has not checked out was last known there
+	for _, stay := range e.Stays {
+		if stay.Status != stayCheckedIn {
+			continue
+		}
+		if lat, lng, ok := stayPosition(stay); ok {
+			seen(LastSeen{Latitude: lat, Longitude: lng, Accuracy: stayLocationAccuracy, At: stay.CheckedInAt, Source: "stay"})
+		}
+	}
+	return e
+}
+
+// stayPosition locates a stay at its property, or at the center of its geohash when the property
+// is no longer registered
+func stayPosition(stay StayDocument) (float64, float64, bool) {
+	if accommodation != nil {
+		if property, err := accommodation.property(stay.ProviderID, stay.PropertyID); err == nil {
+			return property.Latitude, property.Longitude, true
+		}
+	}
+	b, ok := geohashBounds(stay.Geohash)
+	return (b.minLat + b.maxLat) / 2, (b.minLng + b.maxLng) / 2, ok
+}
+
+// plan ranks the places the evidence points at. The last known position is searched first, out to
+// how far the tourist could have walked since; the direction they were heading, an open hotel stay,
+// the itinerary's current and next stops, earlier sightings and recent entries into risky zones
+// follow. Sectors that overlap are merged, and each corroborating source raises the score.
+func (s *missingPersonService) plan(e MissingPersonEvidence, now time.Time) []SearchSector {
+	var candidates []SearchSector
+	add := func(source, label string, lat, lng, radiusKm, score float64) {
+		radiusKm = math.Min(math.Max(radiusKm, minSearchSectorKm), s.maxRadiusKm)
+		candidates = append(candidates, SearchSector{Source: source, Label: label, Latitude: lat, Longitude: lng, RadiusKm: radiusKm, Score: score})
+	}
+	since := func(at string) float64 {
+		t, err := time.Parse(time.RFC3339, at)
+		if err != nil {
+			return 0
+		}
+		return math.Max(now.Sub(t).Hours(), 0)
+	}
+
+	if last := e.LastSeen; last != nil {
+		travelKm := s.walkKmh * since(last.At)
+		add(last.Source, "Last seen", last.Latitude, last.Longitude, last.Accuracy/1000+travelKm, 1)
+		// The newest trail point far enough away to tell a direction from
+		for _, point := range e.Trail {
+			if point.RecordedAt >= last.At {
+				continue
+			}
+			if d := haversineKm(point.Latitude, point.Longitude, last.Latitude, last.Longitude); d*1000 > math.Max(point.Accuracy+last.Accuracy, 100) {
+				if travelKm > minSearchSectorKm {
+					lat, lng := destinationPoint(last.Latitude, last.Longitude, initialBearing(point.Latitude, point.Longitude, last.Latitude, last.Longitude), travelKm/2)
+					add("trail", "Direction of travel", lat, lng, travelKm/2, 0.8)
+				}
+				break
+			}
+		}
+	}
+	for _, sighting := range e.Sightings {
+		if e.LastSeen != nil && e.LastSeen.Source == "sighting" && sighting.SeenAt == e.LastSeen.At {
+			continue
+		}
+		add("sighting", "Sighted by "+sighting.ReportedBy, sighting.Latitude, sighting.Longitude, sighting.Accuracy/1000+s.walkKmh*since(sighting.SeenAt), 0.6)
+	}
+	for _, stay := range e.Stays {
+		lat, lng, ok := stayPosition(stay)
+		switch {
+		case !ok:
+		case stay.Status == stayCheckedIn:
+			add("stay", "Checked in at "+stay.PropertyID, lat, lng, 1, 0.9)
+		case since(stay.CheckedOutAt) <= searchEvidenceWindow.Hours():
+			add("stay", "Checked out of "+stay.PropertyID, lat, lng, 1, 0.5)
+		}
+	}
+	if it := e.Itinerary; it != nil && it.activeAt(now) {
+		radius := func(stop ItineraryStop) float64 {
+			if stop.RadiusKm > 0 {
+				return stop.RadiusKm
+			}
+			return math.Max(it.CorridorKm, 2)
+		}
+		next := false
+		for _, stop := range it.Stops {
+			arrive, depart, ok := stop.stayWindow()
+			switch {
+			case !ok:
+			case !now.Before(arrive) && now.Before(depart):
+				add("itinerary", "Planned stay at "+stop.Name, *stop.Latitude, *stop.Longitude, radius(stop), 0.7)
+			case arrive.After(now) && !next:
+				next = true
+				add("itinerary", "Next planned stop "+stop.Name, *stop.Latitude, *stop.Longitude, radius(stop), 0.5)
+			}
+		}
+	}
+	for _, entry := range e.ZoneEntries {
+		if level := zoneRiskLevel(entry.RiskLevel); level >= 1 && since(entry.At) <= searchEvidenceWindow.Hours() {
+			add("zone", "Entered "+entry.Name, entry.Latitude, entry.Longitude, 1, 0.3+0.1*float64(level))
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].Score > candidates[j].Score })
+	var sectors []SearchSector
+	for _, candidate := range candidates {
+		merged := false
+		for i := range sectors {
+			if haversineKm(sectors[i].Latitude, sectors[i].Longitude, candidate.Latitude, candidate.Longitude) <= sectors[i].RadiusKm {
+				sectors[i].Score = math.Min(sectors[i].Score+0.1*candidate.Score, 1)
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			sectors = append(sectors, candidate)
+		}
+	}
+	sort.SliceStable(sectors, func(i, j int) bool { return sectors[i].Score > sectors[j].Score })
+	sectors = sectors[:min(len(sectors), maxSearchSectors)]
+	for i := range sectors {
+		sectors[i].Rank = i + 1
+		sectors[i].Score = math.Round(sectors[i].Score*100) / 100
+		sectors[i].RadiusKm = math.Round(sectors[i].RadiusKm*100) / 100
+		sectors[i].Geometry = GeoJSONGeometry{Type: "Polygon", Coordinates: [][][2]float64{circleRing(sectors[i].Latitude, sectors[i].Longitude, sectors[i].RadiusKm)}}
+	}
+	if sectors == nil {
+		sectors = []SearchSector{}
+	}
+	return sectors
+}
+
+// searchArea is the convex hull of the sectors, or nil without any
+func searchArea(sectors []SearchSector) *GeoJSONGeometry {
+	var points [][2]float64
+	for _, sector := range sectors {
+		points = append(points, circleRing(sector.Latitude, sector.Longitude, sector.RadiusKm)...)
+	}
+	if len(points) == 0 {
+		return nil
+	}
+	return &GeoJSONGeometry{Type: "Polygon", Coordinates: [][][2]float64{convexHull(points)}}
+}
+
+// circleRing approximates a circle as a closed GeoJSON ring of [longitude, latitude] positions
+func circleRing(lat, lng, radiusKm float64) [][2]float64 {
+	ring := make([][2]float64, 0, searchSectorVertices+1)
+	for i := 0; i < searchSectorVertices; i++ {
+		pLat, pLng := destinationPoint(lat, lng, float64(i)*360/searchSectorVertices, radiusKm)
+		ring = append(ring, [2]float64{math.Round(pLng*1e6) / 1e6, math.Round(pLat*1e6) / 1e6})
+	}
+	return append(ring, ring[0])
+}
+
+// convexHull returns the closed, counterclockwise hull of [longitude, latitude] positions, by
+// Andrew's monotone chain
+func convexHull(points [][2]float64) [][2]float64 {
+	sorted := append([][2]float64(nil), points...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i][0] < sorted[j][0] || sorted[i][0] == sorted[j][0] && sorted[i][1] < sorted[j][1]
+	})
+	cross := func(o, a, b [2]float64) float64 {
+		return (a[0]-o[0])*(b[1]-o[1]) - (a[1]-o[1])*(b[0]-o[0])
+	}
+	var hull [][2]float64
+	for _, pass := range []int{0, 1} {
+		start := len(hull)
+		for k := range sorted {
+			p := sorted[k]
+			if pass == 1 {
+				p = sorted[len(sorted)-1-k]
+			}
+			for len(hull) >= start+2 && cross(hull[len(hull)-2], hull[len(hull)-1], p) <= 0 {
+				hull = hull[:len(hull)-1]
+			}
+			hull = append(hull, p)
+		}
+		hull = hull[:len(hull)-1]
+	}
+	return append(hull, hull[0])
+}
+
+// destinationPoint is the position distanceKm from a start along an initial bearing in degrees
+func destinationPoint(lat, lng, bearing, distanceKm float64) (float64, float64) {
+	const earthRadiusKm = 6371.0
+	rad := math.Pi / 180
+	angular := distanceKm / earthRadiusKm
+	lat1, lng1, theta := lat*rad, lng*rad, bearing*rad
+	lat2 := math.Asin(math.Sin(lat1)*math.Cos(angular) + math.Cos(lat1)*math.Sin(angular)*math.Cos(theta))
+	lng2 := lng1 + math.Atan2(math.Sin(theta)*math.Sin(angular)*math.Cos(lat1), math.Cos(angular)-math.Sin(lat1)*math.Sin(lat2))
+	return lat2 / rad, math.Remainder(lng2/rad, 360)
+}
+
+// initialBearing is the bearing in degrees from one position towards another
+func initialBearing(lat1, lng1, lat2, lng2 float64) float64 {
+	rad := math.Pi / 180
+	dLng := (lng2 - lng1) * rad
+	y := math.Sin(dLng) * math.Cos(lat2*rad)
+	x := math.Cos(lat1*rad)*math.Sin(lat2*rad) - math.Sin(lat1*rad)*math.Cos(lat2*rad)*math.Cos(dLng)
+	return math.Atan2(y, x) / rad
+}
+
+// notifyUnits alerts the police units covering the sectors, in rank order: the units inside each
+// sector, or the nearest unit within MISSING_PERSON_UNIT_RADIUS_KM of a sector with none. Units
+// reach the case through their webhook and the notification gateway, and by push to their zone.
+func (s *missingPersonService) notifyUnits(ctx context.Context, c *MissingPersonCase, message string) {
+	type assignment struct {
+		unit     *PoliceUnit
+		sector   SearchSector
+		distance float64
+	}
+	var assigned []assignment
+	taken := map[string]bool{}
+	take := func(unit *PoliceUnit, sector SearchSector, distance float64) {
+		if len(assigned) < maxCaseUnits && !taken[unit.ID] {
+			taken[unit.ID] = true
+			assigned = append(assigned, assignment{unit, sector, distance})
+		}
+	}
+	for _, sector := range c.Sectors {
+		inside := false
+		for i := range policeUnits {
+			unit := &policeUnits[i]
+			if d := haversineKm(sector.Latitude, sector.Longitude, unit.Latitude, unit.Longitude); unit.kind() == unitPolice && d <= sector.RadiusKm {
+				inside = true
+				take(unit, sector, d)
+			}
+		}
+		if !inside {
+			if unit, d := nearestPoliceUnit(policeUnits, sector.Latitude, sector.Longitude, sector.RadiusKm+s.unitRadiusKm); unit != nil {
+				take(unit, sector, d)
+			}
+		}
+	}
+
+	units := make([]CaseUnit, len(assigned))
+	var wg sync.WaitGroup
+	for i, a := range assigned {
+		units[i] = CaseUnit{UnitID: a.unit.ID, Name: a.unit.Name, Zone: a.unit.zone(), SectorRank: a.sector.Rank, DistanceKm: math.Round(a.distance*100) / 100}
+		wg.Add(1)
+		go func(i int, a assignment) {
+			defer wg.Done()
+			state := s.deliver(ctx, c, a.unit, a.sector, fmt.Sprintf("%s: priority %d of %d, %s", message, a.sector.Rank, len(c.Sectors), a.sector.Label))
+			units[i].State, units[i].NotifiedAt = state, time.Now().UTC().Format(time.RFC3339)
+		}(i, a)
+	}
+	wg.Wait()
+	if len(units) == 0 && len(c.Sectors) > 0 {
+		logWithContext(ctx, "🔎 No police unit covers the search area of %s", c.IncidentID)
+	}
+	c.Units = units
+}
+
+// standDown tells the units searching that the case is over
+func (s *missingPersonService) standDown(ctx context.Context, c *MissingPersonCase, message string) {
+	for _, notified := range c.Units {
+		if unit := policeUnitByID(notified.UnitID); unit != nil {
+			sector := SearchSector{Rank: notified.SectorRank}
+			if notified.SectorRank >= 1 && notified.SectorRank <= len(c.Sectors) {
+				sector = c.Sectors[notified.SectorRank-1]
+			}
+			go s.deliver(context.WithoutCancel(ctx), c, unit, sector, message)
+		}
+	}
+}
+
+// deliver sends one unit the case's alert and returns the delivery state
+func (s *missingPersonService) deliver(ctx context.Context, c *MissingPersonCase, unit *PoliceUnit, sector SearchSector, message string) string {
+	stage := c.Stages[len(c.Stages)-1]
+	if pusher != nil {
+		go pusher.deliver(context.WithoutCancel(ctx), pushAlert{
+			key:   "missing:" + c.IncidentID + ":" + strconv.Itoa(stage.Sequence) + ":" + unit.ID,
+			zone:  unit.zone(),
+			org:   tenantFromContext(ctx).org,
+			title: "Missing person search",
+			body:  message,
+			data:  map[string]string{"type": "missing_person", "incident_id": c.IncidentID, "digital_id": c.DigitalID, "stage": stage.Stage, "sector_rank": strconv.Itoa(sector.Rank)},
+		})
+	}
+	alert := SOSNotification{
+		AlertID:    c.IncidentID,
+		DigitalID:  c.DigitalID,
+		Latitude:   sector.Latitude,
+		Longitude:  sector.Longitude,
+		Accuracy:   sector.RadiusKm * 1000,
+		Message:    message,
+		Source:     "missing_person",
+		RaisedAt:   c.OpenedAt,
+		MapURL:     fmt.Sprintf("https://maps.google.com/?q=%.6f,%.6f", sector.Latitude, sector.Longitude),
+		PoliceUnit: unit.Name,
+		TxID:       stage.TxID,
+	}
+	return sosNotifier.fanOut(ctx, alert, unit, nil).State
+}
+
+// updateIncident moves the incident to the status the case ended in, unless it is already there
+// or past it
+func (s *missingPersonService) updateIncident(ctx context.Context, c *MissingPersonCase, status, actor string) {
+	incident, err := ledger.GetIncident(ctx, c.IncidentID)
+	if err != nil {
+		logWithContext(ctx, "🔎 Failed to read incident %s: %v", c.IncidentID, err)
+		return
+	}
+	if incident.Status == status || incident.Status == "closed" {
+		return
+	}
+	if _, err := ledger.UpdateIncidentStatus(ctx, c.IncidentID, UpdateIncidentStatusRequest{Status: status, Updater: actor}); err != nil {
+		logWithContext(ctx, "🔎 Failed to mark incident %s %s: %v", c.IncidentID, status, err)
+	}
+}
+
+// openFiledMissingPerson opens the case of an incident just filed. It runs in the background of
+// CreateIncident, so failures are only logged; the case can be opened again through the API.
+func openFiledMissingPerson(ctx context.Context, incidentID, digitalID, actor string) {
+	if missingPersons == nil {
+		return
+	}
+	if _, _, err := missingPersons.open(ctx, incidentID, digitalID, actor); err != nil {
+		logWithContext(ctx, "🔎 Failed to open the missing person case %s: %v", incidentID, err)
+	}
+}
+
+// missingPersonIncident reads an incident the caller's organization owns and checks it reports a
+// missing tourist
+func missingPersonIncident(ctx context.Context, incidentID string) (IncidentDocument, error) {
+	incident, err := ledger.ownedIncident(ctx, incidentID)
+	if err != nil {
+		return incident, err
+	}
+	if incident.Category != "missing_person" || incident.SubjectID == "" {
+		return incident, ValidationErrors{{Field: "incidentID", Message: "must be a missing person incident naming the tourist"}}
+	}
+	return incident, nil
+}
+
+func (ledgerService) ListCaseAnchors(ctx context.Context, incidentID string) ([]CaseAnchor, error) {
+	if errs := validateDocumentID("incidentId", incidentID); len(errs) > 0 {
+		return nil, errs
+	}
+	result, err := evaluateTransaction(ctx, "GetCaseAnchors", incidentID)
+	if err != nil {
+		return nil, err
+	}
+	return decodeDocument[[]CaseAnchor](result, "case anchor")
+}
+
+func openMissingPersonCase(c *gin.Context) {
+	var req OpenMissingPersonRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+	setAuditTarget(c, req.IncidentID)
+	ctx := c.Request.Context()
+	incident, err := missingPersonIncident(ctx, req.IncidentID)
+	if err != nil {
+		respondServiceError(c, "Failed to open missing person case", err)
+		return
+	}
+	missingCase, created, err := missingPersons.open(ctx, incident.IncidentID, incident.SubjectID, req.Actor)
+	if err != nil {
+		respondServiceError(c, "Failed to open missing person case", err)
+		return
+	}
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	respondData(c, status, missingCase)
+}
+
+func getMissingPersonCase(c *gin.Context) {
+	id, ok := validPathID(c, "incidentId")
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	// The incident is read first so other organizations cannot see the case
+	if _, err := missingPersonIncident(ctx, id); err != nil {
+		respondServiceError(c, "Failed to read missing person case", err)
+		return
+	}
+	missingCase, err := missingPersons.store.load(ctx, id)
+	if err != nil {
+		respondServiceError(c, "Failed to read missing person case", err)
+		return
+	}
+	if missingCase == nil {
+		respondError(c, http.StatusNotFound, errCodeNotFound, "Missing person case not found")
+		return
+	}
+	respondData(c, http.StatusOK, missingCase)
+}
+
+func advanceMissingPersonCase(c *gin.Context) {
+	id, ok := validPathID(c, "incidentId")
+	if !ok {
+		return
+	}
+	var req CaseStageRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+	setAuditTarget(c, id)
+	ctx := c.Request.Context()
+	if _, err := missingPersonIncident(ctx, id); err != nil {
+		respondServiceError(c, "Failed to update missing person case", err)
+		return
+	}
+	missingCase, err := missingPersons.advance(ctx, id, req)
+	if err != nil {
+		respondServiceError(c, "Failed to update missing person case", err)
+		return
+	}
+	if missingCase == nil {
+		respondError(c, http.StatusNotFound, errCodeNotFound, "Missing person case not found")
+		return
+	}
+	respondData(c, http.StatusOK, missingCase)
+}
+
+func listCaseAnchors(c *gin.Context) {
+	id, ok := validPathID(c, "incidentId")
+	if !ok {
+		return
+	}
+	anchors, err := ledger.ListCaseAnchors(c.Request.Context(), id)
+	if err != nil {
+		respondServiceError(c, "Failed to list case anchors", err)
+		return
+	}
+	respondData(c, http.StatusOK, anchors)
+}
+
+// redisMissingPersonStore keeps a key per case
+type redisMissingPersonStore struct {
+	redis *redisClient
+	ttl   time.Duration
+}
+
+func (s redisMissingPersonStore) create(ctx context.Context, c MissingPersonCase) (bool, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return false, err
+	}
+	_, err = s.redis.Do(ctx, "SET", missingCasePrefix+c.IncidentID, string(data), "NX", "PX", strconv.FormatInt(s.ttl.Milliseconds(), 10))
+	if errors.Is(err, errRedisNil) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s redisMissingPersonStore) load(ctx context.Context, incidentID string) (*MissingPersonCase, error) {
+	data, err := s.redis.Get(ctx, missingCasePrefix+incidentID)
+	if errors.Is(err, errRedisNil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var c MissingPersonCase
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (s redisMissingPersonStore) save(ctx context.Context, c MissingPersonCase) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return s.redis.Set(ctx, missingCasePrefix+c.IncidentID, data, s.ttl)
+}
+
+// memoryMissingPersonStore serves a single gateway instance. Cases are kept as JSON so callers
+// never share their slices.
+type memoryMissingPersonStore struct {
+	mu    sync.Mutex
+	cases map[string][]byte
+}
+
+func newMemoryMissingPersonStore() *memoryMissingPersonStore {
+	return &memoryMissingPersonStore{cases: map[string][]byte{}}
+}
+
+func (s *memoryMissingPersonStore) create(_ context.Context, c MissingPersonCase) (bool, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return false, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.cases[c.IncidentID]; ok {
+		return false, nil
+	}
+	s.cases[c.IncidentID] = data
+	return true, nil
+}
+
+func (s *memoryMissingPersonStore) load(_ context.Context, incidentID string) (*MissingPersonCase, error) {
+	s.mu.Lock()
+	data, ok := s.cases[incidentID]
+	s.mu.Unlock()
+	if !ok {
+		return nil, nil
+	}
+	var c MissingPersonCase
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (s *memoryMissingPersonStore) save(_ context.Context, c MissingPersonCase) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cases[c.IncidentID] = data
+	return nil
+}
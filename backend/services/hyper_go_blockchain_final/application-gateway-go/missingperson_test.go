@@ -0,0 +1,182 @@
+package gateway
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestMissingPersonSearchPlan(t *testing.T) {
+	s := &missingPersonService{walkKmh: 4, maxRadiusKm: 20, unitRadiusKm: 25}
+	now := time.Date(2025, 9, 20, 14, 0, 0, 0, time.UTC)
+	at := func(ago time.Duration) string { return now.Add(-ago).Format(time.RFC3339) }
+	stopLat, stopLng := 25.45, 91.70
+	startLat, startLng := 25.60, 91.90
+
+	// Last seen two hours ago walking south-west from the market; checked into a homestay far away,
+	// with the next planned stop and a restricted gorge entry on the way
+	e := MissingPersonEvidence{
+		LastSeen: &LastSeen{Latitude: 25.5788, Longitude: 91.8933, Accuracy: 20, At: at(2 * time.Hour), Source: "ping"},
+		Trail: []TrailPoint{
+			{Latitude: 25.5788, Longitude: 91.8933, Accuracy: 20, RecordedAt: at(2 * time.Hour)},
+			{Latitude: 25.5900, Longitude: 91.9050, Accuracy: 20, RecordedAt: at(150 * time.Minute)},
+		},
+		Stays: []StayDocument{{StayID: "stay_1", PropertyID: "laitlum_1", Status: stayCheckedIn, Geohash: encodeGeohash(25.40, 91.95, 6), CheckedInAt: at(30 * time.Hour)}},
+		Itinerary: &Itinerary{
+			StartsAt: at(48 * time.Hour), EndsAt: now.Add(48 * time.Hour).Format(time.RFC3339), CorridorKm: 1,
+			Stops: []ItineraryStop{
+				{Name: "Shillong", Latitude: &startLat, Longitude: &startLng},
+				{Name: "Mawlynnong", Latitude: &stopLat, Longitude: &stopLng, ArriveAt: now.Add(time.Hour).Format(time.RFC3339), DepartAt: now.Add(5 * time.Hour).Format(time.RFC3339)},
+			},
+		},
+		ZoneEntries: []GeofenceEvent{
+			{Type: geofenceZoneEntry, ZoneID: "falls", Name: "Elephant Falls gorge", RiskLevel: "restricted", Latitude: 25.54, Longitude: 91.82, At: at(3 * time.Hour)},
+			{Type: geofenceZoneEntry, ZoneID: "market", Name: "Police Bazar", RiskLevel: "low", Latitude: 25.575, Longitude: 91.88, At: at(4 * time.Hour)},
+		},
+	}
+	sectors := s.plan(e, now)
+
+	sources := map[string]SearchSector{}
+	for i, sector := range sectors {
+		if sector.Rank != i+1 || i > 0 && sector.Score > sectors[i-1].Score {
+			t.Fatalf("sectors are not ranked by score: %+v", sectors)
+		}
+		sources[sector.Source+":"+sector.Label] = sector
+	}
+	last := sectors[0]
+	if last.Label != "Last seen" || math.Abs(last.RadiusKm-8.02) > 0.01 {
+		t.Errorf("expected the last known position searched first out to 8 km, got %+v", last)
+	}
+	// The projected position and the gorge fall inside the last seen sector and corroborate it
+	if _, ok := sources["trail:Direction of travel"]; ok || last.Score != 1 {
+		t.Errorf("expected the direction of travel merged into the last seen sector, got %+v", sectors)
+	}
+	if stay, ok := sources["stay:Checked in at laitlum_1"]; !ok || stay.Rank != 2 {
+		t.Errorf("expected the open stay ranked second, got %+v", sectors)
+	}
+	if _, ok := sources["itinerary:Next planned stop Mawlynnong"]; !ok {
+		t.Errorf("expected the next planned stop searched, got %+v", sectors)
+	}
+	for _, sector := range sectors {
+		if sector.Source == "zone" && sector.Label == "Entered Police Bazar" {
+			t.Errorf("expected low risk zones ignored, got %+v", sector)
+		}
+	}
+
+	area := searchArea(sectors)
+	ring := area.Coordinates.([][][2]float64)[0]
+	if ring[0] != ring[len(ring)-1] {
+		t.Fatal("expected a closed ring")
+	}
+	for _, sector := range sectors {
+		if !zoneRing(ring).contains(sector.Longitude, sector.Latitude) {
+			t.Errorf("expected the search area to contain sector %d", sector.Rank)
+		}
+	}
+	if searchArea(s.plan(MissingPersonEvidence{}, now)) != nil {
+		t.Error("expected no search area without evidence")
+	}
+}
+
+func TestConvexHull(t *testing.T) {
+	hull := convexHull([][2]float64{{0, 0}, {2, 0}, {1, 1}, {2, 2}, {0, 2}, {1, 0}})
+	want := [][2]float64{{0, 0}, {2, 0}, {2, 2}, {0, 2}, {0, 0}}
+	if len(hull) != len(want) {
+		t.Fatalf("got hull %v, expected %v", hull, want)
+	}
+	for i := range want {
+		if hull[i] != want[i] {
+			t.Fatalf("got hull %v, expected %v", hull, want)
+		}
+	}
+	lat, lng := destinationPoint(25.5788, 91.8933, 90, 10)
+	if d := haversineKm(25.5788, 91.8933, lat, lng); math.Abs(d-10) > 0.001 || lng <= 91.8933 {
+		t.Errorf("expected a point 10 km east, got %f,%f at %.3f km", lat, lng, d)
+	}
+	if bearing := initialBearing(25.5788, 91.8933, lat, lng); math.Abs(bearing-90) > 0.1 {
+		t.Errorf("expected a bearing of 90, got %f", bearing)
+	}
+}
+
+func TestCaseStageRequestValidation(t *testing.T) {
+	lat, lng := 25.5788, 91.8933
+	sighting := CaseStageRequest{Stage: caseStageSighted, Actor: "officer_7", Latitude: &lat, Longitude: &lng, SeenAt: time.Now().Add(-time.Hour).Format(time.RFC3339)}
+	if errs := sighting.Validate(); len(errs) > 0 {
+		t.Fatalf("unexpected errors %v", errs)
+	}
+	sighting.Latitude, sighting.SeenAt = nil, ""
+	if errs := sighting.Validate(); len(errs) != 2 {
+		t.Errorf("expected a sighting without a position or time rejected, got %v", errs)
+	}
+	if errs := (CaseStageRequest{Stage: caseStageOpened, Actor: "officer_7", NoteHash: "abc"}).Validate(); len(errs) != 2 {
+		t.Errorf("expected the stage and note hash rejected, got %v", errs)
+	}
+	if errs := (CaseStageRequest{Stage: caseStageFound, Actor: "officer_7"}).Validate(); len(errs) > 0 {
+		t.Errorf("expected found to need no position, got %v", errs)
+	}
+}
+
+func TestMissingPersonCaseStages(t *testing.T) {
+	previousUnits, previousNotifier := policeUnits, sosNotifier
+	policeUnits = []PoliceUnit{
+		{ID: "PS-SHILLONG", Name: "Shillong Sadar", Latitude: 25.57, Longitude: 91.88},
+		{ID: "PS-SOHRA", Name: "Sohra", Latitude: 25.27, Longitude: 91.73},
+		{ID: "AMB-1", Name: "Ambulance", Kind: unitMedical, Latitude: 25.578, Longitude: 91.893},
+	}
+	sosNotifier = &notifier{timeout: time.Second, wait: time.Second}
+	defer func() { policeUnits, sosNotifier = previousUnits, previousNotifier }()
+
+	s := &missingPersonService{store: newMemoryMissingPersonStore(), walkKmh: 4, maxRadiusKm: 20, unitRadiusKm: 25}
+	ctx := context.Background()
+	now := time.Now().UTC()
+	c := &MissingPersonCase{IncidentID: "INC-77", DigitalID: "did:sih:tourist_001", OpenedAt: now.Format(time.RFC3339)}
+	c.Evidence = MissingPersonEvidence{LastSeen: &LastSeen{Latitude: 25.5788, Longitude: 91.8933, At: now.Format(time.RFC3339), Source: "ping"}}
+	c.Sectors = s.plan(c.Evidence, now)
+	s.stage(c, caseStageOpened, "officer_7", "", now)
+
+	opening := c.Stages[0]
+	if opening.Sequence != 1 || opening.AnchorID != "CASE:INC-77:1" || len(opening.Digest) != 64 || c.Stage != caseStageOpened {
+		t.Fatalf("unexpected opening %+v", opening)
+	}
+	// A digest is fixed before its stage is anchored, so it must not cover transaction IDs
+	anchored, unanchored := *c, *c
+	anchored.Stages = []CaseStage{opening}
+	anchored.Stages[0].TxID = "tx1"
+	s.stage(&anchored, caseStageClosed, "officer_7", "", now)
+	s.stage(&unanchored, caseStageClosed, "officer_7", "", now)
+	if closing := anchored.Stages[1]; closing.Sequence != 2 || closing.Digest != unanchored.Stages[1].Digest || closing.Digest == opening.Digest {
+		t.Fatalf("unexpected stages %+v", anchored.Stages)
+	}
+
+	// Only the police unit within reach of the last seen sector is notified
+	s.notifyUnits(ctx, c, "Missing person search")
+	if len(c.Units) != 1 || c.Units[0].UnitID != "PS-SHILLONG" || c.Units[0].SectorRank != 1 || c.Units[0].State != "none" {
+		t.Errorf("unexpected units %+v", c.Units)
+	}
+
+	if created, err := s.store.create(ctx, *c); err != nil || !created {
+		t.Fatal("expected the case created")
+	}
+	if created, _ := s.store.create(ctx, *c); created {
+		t.Error("expected a second case for the incident refused")
+	}
+	loaded, _ := s.store.load(ctx, "INC-77")
+	loaded.Units[0].State = "changed"
+	if reloaded, _ := s.store.load(ctx, "INC-77"); reloaded.Units[0].State != "none" {
+		t.Error("expected loaded cases not to share state")
+	}
+
+	// A case whose opening is not yet on the ledger cannot move on
+	if _, err := s.advance(ctx, "INC-77", CaseStageRequest{Stage: caseStageFound, Actor: "officer_7"}); err == nil {
+		t.Error("expected an unanchored case held")
+	}
+	loaded.Stages[0].TxID, loaded.Stage = "tx1", caseStageFound
+	s.store.save(ctx, *loaded)
+	if _, err := s.advance(ctx, "INC-77", CaseStageRequest{Stage: caseStageSighted, Actor: "officer_7"}); err == nil {
+		t.Error("expected a sighting after the tourist was found rejected")
+	}
+	if missing, _ := s.advance(ctx, "INC-404", CaseStageRequest{Stage: caseStageClosed, Actor: "officer_7"}); missing != nil {
+		t.Error("expected no case for an unknown incident")
+	}
+}
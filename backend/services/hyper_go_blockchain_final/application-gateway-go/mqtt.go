@@ -0,0 +1,211 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+// mqttClient subscribes to device telemetry through the Eclipse Paho client. It keeps a persistent
+// session, so QoS 1 messages published while the gateway is down are delivered when it reconnects,
+// and acknowledges each message once its handler returns.
+type mqttClient struct {
+	addr      string
+	opts      *paho.ClientOptions
+	keepAlive time.Duration
+	timeout   time.Duration
+}
+
+// mqttMessage is a PUBLISH received from the broker
+type mqttMessage struct {
+	topic   string
+	payload []byte
+}
+
+// newMQTTClient parses a mqtt://[user:password@]host[:port] or mqtts:// URL
+func newMQTTClient(rawURL, clientID string, keepAlive, timeout time.Duration) (*mqttClient, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MQTT URL: %w", err)
+	}
+	if len(clientID) == 0 || len(clientID) > 65535 {
+		return nil, fmt.Errorf("MQTT client ID must be 1-65535 bytes")
+	}
+	opts := paho.NewClientOptions().
+		SetClientID(clientID).
+		SetCleanSession(false).
+		SetOrderMatters(true).
+		SetAutoAckDisabled(true).
+		SetAutoReconnect(false).
+		SetKeepAlive(keepAlive).
+		SetPingTimeout(timeout).
+		SetConnectTimeout(timeout).
+		SetWriteTimeout(timeout)
+	port := "1883"
+	switch u.Scheme {
+	case "mqtt", "tcp":
+	case "mqtts", "ssl":
+		opts.SetTLSConfig(&tls.Config{ServerName: u.Hostname(), MinVersion: tls.VersionTLS12})
+		port = "8883"
+	default:
+		return nil, fmt.Errorf("unsupported MQTT URL scheme %q", u.Scheme)
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), port)
+	}
+	opts.AddBroker((&url.URL{Scheme: u.Scheme, Host: addr}).String())
+	if u.User != nil {
+		password, _ := u.User.Password()
+		opts.SetUsername(u.User.Username()).SetPassword(password)
+	}
+	return &mqttClient{addr: addr, opts: opts, keepAlive: keepAlive, timeout: timeout}, nil
+}
+
+// mqttClientFromEnv configures a client for MQTT_BROKER_URL. Each subscriber appends its own suffix
+// to MQTT_CLIENT_ID, since the broker keeps one persistent session per client ID.
+func mqttClientFromEnv(broker, suffix string) *mqttClient {
+	hostname, _ := os.Hostname()
+	keepAlive := getEnvDuration("MQTT_KEEPALIVE", 60*time.Second)
+	if keepAlive < 10*time.Second || keepAlive > 18*time.Hour {
+		panic(fmt.Errorf("MQTT_KEEPALIVE must be between 10s and 18h"))
+	}
+	client, err := newMQTTClient(broker, getEnv("MQTT_CLIENT_ID", "sih-gateway-"+hostname)+suffix, keepAlive, getEnvDuration("MQTT_TIMEOUT", 10*time.Second))
+	if err != nil {
+		panic(err)
+	}
+	return client
+}
+
+// run keeps a session open until ctx ends, reconnecting with backoff
+func (m *mqttClient) run(ctx context.Context, topics []string, handle func(context.Context, mqttMessage) error) {
+	backoff := time.Second
+	for ctx.Err() == nil {
+		started := time.Now()
+		err := m.session(ctx, topics, handle)
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(started) > time.Minute {
+			backoff = time.Second
+		}
+		log.Printf("📡 MQTT session with %s ended: %v; reconnecting in %s", m.addr, err, backoff)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, time.Minute)
+	}
+}
+
+// session connects, subscribes at QoS 1 and delivers messages in order until the connection or a
+// handler fails. Paho does not reconnect on its own here: a failed handler ends the session, so the
+// broker redelivers the unacknowledged message on the next one.
+func (m *mqttClient) session(ctx context.Context, topics []string, handle func(context.Context, mqttMessage) error) error {
+	ended := make(chan error, 1)
+	end := func(err error) {
+		select {
+		case ended <- err:
+		default:
+		}
+	}
+	failed := false
+	opts := *m.opts
+	// Messages queued in the persistent session can arrive before SUBSCRIBE is acknowledged, so
+	// every message goes through the default handler. Paho calls it from one goroutine, in order.
+	opts.SetDefaultPublishHandler(func(_ paho.Client, msg paho.Message) {
+		if failed {
+			return // left unacknowledged until the next session
+		}
+		if err := handle(ctx, mqttMessage{topic: msg.Topic(), payload: msg.Payload()}); err != nil {
+			failed = true
+			end(err)
+			return
+		}
+		msg.Ack()
+	})
+	opts.SetConnectionLostHandler(func(_ paho.Client, err error) { end(err) })
+
+	client := paho.NewClient(&opts)
+	if err := mqttWait(ctx, client.Connect(), m.timeout); err != nil {
+		return err
+	}
+	defer client.Disconnect(uint(m.timeout / time.Millisecond))
+
+	filters := make(map[string]byte, len(topics))
+	for _, topic := range topics {
+		filters[topic] = 1
+	}
+	subscribe := client.SubscribeMultiple(filters, nil)
+	if err := mqttWait(ctx, subscribe, m.timeout); err != nil {
+		return err
+	}
+	for topic, code := range subscribe.(*paho.SubscribeToken).Result() {
+		if code == 0x80 {
+			return fmt.Errorf("broker refused subscription to %s", topic)
+		}
+	}
+	log.Printf("📡 Subscribed to %s on %s", strings.Join(topics, ", "), m.addr)
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-ended:
+		return err
+	}
+}
+
+// mqttWait waits for a Paho operation to complete, for at most timeout
+func mqttWait(ctx context.Context, token paho.Token, timeout time.Duration) error {
+	select {
+	case <-token.Done():
+		return token.Error()
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(timeout):
+		return errors.New("timed out waiting for the MQTT broker")
+	}
+}
+
+// mqttTopicLevel returns the topic level matched by the first "+" wildcard of filter, such as the
+// device ID of "sih/bands/+/telemetry"
+func mqttTopicLevel(filter, topic string) (string, bool) {
+	filterLevels, topicLevels := strings.Split(filter, "/"), strings.Split(topic, "/")
+	if strings.HasPrefix(filter, "$share/") && len(filterLevels) > 2 {
+		filterLevels = filterLevels[2:]
+	}
+	captured, found := "", false
+	for i, level := range filterLevels {
+		if level == "#" {
+			return captured, found
+		}
+		if i >= len(topicLevels) {
+			return "", false
+		}
+		switch {
+		case level == "+":
+			if !found {
+				captured, found = topicLevels[i], true
+			}
+		case level != topicLevels[i]:
+			return "", false
+		}
+	}
+	return captured, found && len(filterLevels) == len(topicLevels)
+}
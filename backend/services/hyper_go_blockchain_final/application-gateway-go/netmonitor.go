@@ -0,0 +1,704 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	callEvaluate = "evaluate"
+	callSubmit   = "submit"
+
+	// monitorBuckets is how many one-minute buckets of calls are kept for the error rate
+	monitorBuckets = 15
+	// monitorResolvedKept is how many resolved alerts the health report keeps
+	monitorResolvedKept = 50
+
+	alertLedgerStalled = "ledger_stalled"
+	alertPeerLag       = "peer_lag"
+	alertListenerDown  = "event_listener_down"
+	alertErrorRate     = "chaincode_error_rate"
+)
+
+// commitLatencyBuckets are the upper bounds, in seconds, of the commit latency histogram
+var commitLatencyBuckets = []float64{0.25, 0.5, 1, 2, 5, 10, 30, 60}
+
+// PeerHeight is a peer's ledger height on a channel, as discovery last reported it, and how
+// many blocks it trails the highest peer
+type PeerHeight struct {
+	Channel      string `json:"channel"`
+	Endpoint     string `json:"endpoint"`
+	MSPID        string `json:"msp_id"`
+	LedgerHeight uint64 `json:"ledger_height"`
+	Lag          uint64 `json:"lag"`
+}
+
+// CommitLatency summarizes how long submitted transactions took from the orderer to commit.
+// The percentiles are estimated from the histogram buckets.
+type CommitLatency struct {
+	Count uint64  `json:"count"`
+	AvgMS float64 `json:"avg_ms"`
+	P50MS float64 `json:"p50_ms"`
+	P95MS float64 `json:"p95_ms"`
+}
+
+// EventListenerHealth is the state of a target's chaincode event stream
+type EventListenerHealth struct {
+	Running        bool   `json:"running"`
+	Events         uint64 `json:"events"`
+	LastEventBlock uint64 `json:"last_event_block,omitempty"`
+	LastEventAt    string `json:"last_event_at,omitempty"`
+}
+
+// TargetHealth is one target's ledger as the monitor last sampled it. PendingSince is when the
+// first transaction was submitted after the height last moved; the ledger is stalled when one has
+// waited FABRIC_STALL_AFTER without a new block.
+type TargetHealth struct {
+	Name            string              `json:"name"`
+	Channel         string              `json:"channel"`
+	LedgerHeight    uint64              `json:"ledger_height"`
+	HeightChangedAt string              `json:"height_changed_at,omitempty"`
+	SampledAt       string              `json:"sampled_at,omitempty"`
+	SampleError     string              `json:"sample_error,omitempty"`
+	PendingSince    string              `json:"pending_since,omitempty"`
+	Stalled         bool                `json:"stalled"`
+	CommitLatency   CommitLatency       `json:"commit_latency"`
+	EventListener   EventListenerHealth `json:"event_listener"`
+	Peers           []PeerHeight        `json:"peers"`
+}
+
+// TransactionCalls counts the calls to one chaincode function and their errors by code
+type TransactionCalls struct {
+	Kind        string            `json:"kind"`
+	Transaction string            `json:"transaction"`
+	Calls       uint64            `json:"calls"`
+	Errors      map[string]uint64 `json:"errors"`
+}
+
+// CallWindow is the chaincode error rate over the recent window. Faults are errors that say the
+// network failed, answered with a 5xx, rather than that the chaincode refused the request.
+type CallWindow struct {
+	Window    string  `json:"window"`
+	Calls     uint64  `json:"calls"`
+	Faults    uint64  `json:"faults"`
+	ErrorRate float64 `json:"error_rate"`
+}
+
+// FabricAlert is a condition the monitor raised. It is resolved once the condition clears.
+type FabricAlert struct {
+	Key        string `json:"key"`
+	Kind       string `json:"kind"`
+	Target     string `json:"target,omitempty"`
+	Peer       string `json:"peer,omitempty"`
+	Message    string `json:"message"`
+	Since      string `json:"since"`
+	ResolvedAt string `json:"resolved_at,omitempty"`
+}
+
+// FabricNetworkHealth is the monitor's report
+type FabricNetworkHealth struct {
+	CheckedAt    string             `json:"checked_at"`
+	Circuit      string             `json:"circuit"`
+	Targets      []TargetHealth     `json:"targets"`
+	Recent       CallWindow         `json:"recent"`
+	Transactions []TransactionCalls `json:"transactions"`
+	Alerts       []FabricAlert      `json:"alerts"`
+	Resolved     []FabricAlert      `json:"resolved"`
+}
+
+// FabricAlertNotification is posted to FABRIC_ALERT_WEBHOOK_URL when an alert is raised or resolved
+type FabricAlertNotification struct {
+	FabricAlert
+	State string `json:"state"`
+}
+
+type latencyHistogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func (h *latencyHistogram) observe(seconds float64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(commitLatencyBuckets)+1)
+	}
+	i := sort.SearchFloat64s(commitLatencyBuckets, seconds)
+	h.counts[i]++
+	h.sum += seconds
+	h.count++
+}
+
+// quantile interpolates within the bucket holding the q-th observation; the last bucket has no
+// upper bound, so observations in it count as the highest bound
+func (h *latencyHistogram) quantile(q float64) float64 {
+	if h.count == 0 {
+		return 0
+	}
+	rank := q * float64(h.count)
+	var seen float64
+	for i, n := range h.counts {
+		if n == 0 || seen+float64(n) < rank {
+			seen += float64(n)
+			continue
+		}
+		if i == len(commitLatencyBuckets) {
+			return commitLatencyBuckets[i-1]
+		}
+		lower := 0.0
+		if i > 0 {
+			lower = commitLatencyBuckets[i-1]
+		}
+		return lower + (commitLatencyBuckets[i]-lower)*(rank-seen)/float64(n)
+	}
+	return commitLatencyBuckets[len(commitLatencyBuckets)-1]
+}
+
+func (h *latencyHistogram) summary() CommitLatency {
+	if h.count == 0 {
+		return CommitLatency{}
+	}
+	return CommitLatency{
+		Count: h.count,
+		AvgMS: math.Round(h.sum / float64(h.count) * 1000),
+		P50MS: math.Round(h.quantile(0.5) * 1000),
+		P95MS: math.Round(h.quantile(0.95) * 1000),
+	}
+}
+
+type callKey struct {
+	kind, transaction string
+}
+
+type minuteBucket struct {
+	minute int64
+	calls  uint64
+	faults uint64
+}
+
+type targetMonitor struct {
+	channel         string
+	height          uint64
+	heightChangedAt time.Time
+	sampledAt       time.Time
+	sampleErr       string
+	failingSince    time.Time
+	pendingSince    time.Time
+	commits         latencyHistogram
+	listening       bool
+	events          uint64
+	lastEventBlock  uint64
+	lastEventAt     time.Time
+}
+
+// networkMonitor watches the Fabric network from the gateway's side: the ledger height of every
+// target, the heights discovery reports for each peer, commit latency, chaincode errors and the
+// event listeners. It raises an alert when the ledger stops advancing, a peer falls behind, a
+// listener stops or faults climb.
+type networkMonitor struct {
+	interval   time.Duration
+	stallAfter time.Duration
+	peerLag    uint64
+	window     time.Duration
+	errorRate  float64
+	minCalls   uint64
+	alerter    *notifier
+	alertEmail string
+
+	mu       sync.Mutex
+	targets  map[string]*targetMonitor
+	peers    []PeerHeight
+	calls    map[callKey]map[string]uint64
+	buckets  [monitorBuckets]minuteBucket
+	alerts   map[string]FabricAlert
+	resolved []FabricAlert
+}
+
+// fabricMonitor records every Fabric call the gateway makes; initNetworkMonitor configures it
+var fabricMonitor = newNetworkMonitor()
+
+func newNetworkMonitor() *networkMonitor {
+	return &networkMonitor{
+		interval:   30 * time.Second,
+		stallAfter: 5 * time.Minute,
+		peerLag:    10,
+		window:     5 * time.Minute,
+		errorRate:  0.2,
+		minCalls:   20,
+		targets:    map[string]*targetMonitor{},
+		calls:      map[callKey]map[string]uint64{},
+		alerts:     map[string]FabricAlert{},
+	}
+}
+
+// initNetworkMonitor applies FABRIC_MONITOR_* settings. Alerts are logged, and also posted to
+// FABRIC_ALERT_WEBHOOK_URL and mailed to FABRIC_ALERT_EMAIL when those are set.
+func initNetworkMonitor() {
+	m := newNetworkMonitor()
+	m.interval = getEnvDuration("FABRIC_MONITOR_INTERVAL", m.interval)
+	m.stallAfter = getEnvDuration("FABRIC_STALL_AFTER", m.stallAfter)
+	m.peerLag = uint64(max(getEnvInt("FABRIC_PEER_LAG_ALERT", int(m.peerLag)), 1))
+	m.window = min(getEnvDuration("FABRIC_ERROR_WINDOW", m.window), monitorBuckets*time.Minute)
+	if rate, err := strconv.ParseFloat(getEnv("FABRIC_ERROR_RATE_ALERT", "0.2"), 64); err == nil && rate > 0 && rate <= 1 {
+		m.errorRate = rate
+	}
+	m.minCalls = uint64(max(getEnvInt("FABRIC_ERROR_MIN_CALLS", int(m.minCalls)), 1))
+	if url := getEnv("FABRIC_ALERT_WEBHOOK_URL", ""); url != "" {
+		timeout := getEnvDuration("FABRIC_ALERT_TIMEOUT", 5*time.Second)
+		m.alerter = &notifier{gatewayURL: url, secret: []byte(getEnv("NOTIFY_WEBHOOK_SECRET", "")), client: &http.Client{Timeout: timeout}, timeout: timeout}
+	}
+	m.alertEmail = getEnv("FABRIC_ALERT_EMAIL", "")
+	for _, target := range sortedTargets() {
+		m.target(target.Name).channel = target.Channel
+	}
+	fabricMonitor = m
+	log.Printf("🩺 Fabric network monitor sampling every %s, ledger stall alert after %s", m.interval, m.stallAfter)
+}
+
+// target returns a target's state, creating it; callers hold mu
+func (m *networkMonitor) target(name string) *targetMonitor {
+	t, ok := m.targets[name]
+	if !ok {
+		t = &targetMonitor{}
+		m.targets[name] = t
+	}
+	return t
+}
+
+// recordCall counts a chaincode call and, for errors, their code
+func (m *networkMonitor) recordCall(kind, transaction string, err error, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := callKey{kind, transaction}
+	if m.calls[key] == nil {
+		m.calls[key] = map[string]uint64{}
+	}
+	code := ""
+	fault := false
+	if err != nil {
+		translated := translateFabricError(err)
+		code, fault = translated.Code, translated.Status >= http.StatusInternalServerError
+	}
+	m.calls[key][code]++
+
+	minute := now.Unix() / 60
+	bucket := &m.buckets[minute%monitorBuckets]
+	if bucket.minute != minute {
+		*bucket = minuteBucket{minute: minute}
+	}
+	bucket.calls++
+	if fault {
+		bucket.faults++
+	}
+}
+
+// submitted notes a transaction sent to the orderer, which should produce a block
+func (m *networkMonitor) submitted(target string, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if t := m.target(target); t.pendingSince.IsZero() {
+		t.pendingSince = now
+	}
+}
+
+// committed records a transaction's commit, which also proves the ledger reached its block
+func (m *networkMonitor) committed(target string, blockNumber uint64, latency time.Duration, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t := m.target(target)
+	t.commits.observe(latency.Seconds())
+	t.advance(blockNumber+1, now)
+}
+
+// advance raises the known height, clearing what was pending when it moves
+func (t *targetMonitor) advance(height uint64, now time.Time) {
+	if height > t.height {
+		t.height, t.heightChangedAt, t.pendingSince = height, now, time.Time{}
+	}
+}
+
+func (m *networkMonitor) listening(target string, running bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.target(target).listening = running
+}
+
+func (m *networkMonitor) event(target string, blockNumber uint64, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t := m.target(target)
+	t.events++
+	t.lastEventBlock, t.lastEventAt = blockNumber, now
+	t.advance(blockNumber+1, now)
+}
+
+// sampled records a height read from the gateway peer, or the error reading it
+func (m *networkMonitor) sampled(target string, height uint64, err error, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t := m.target(target)
+	t.sampledAt = now
+	if err != nil {
+		t.sampleErr = err.Error()
+		if t.failingSince.IsZero() {
+			t.failingSince = now
+		}
+		return
+	}
+	t.sampleErr, t.failingSince = "", time.Time{}
+	if t.heightChangedAt.IsZero() {
+		t.heightChangedAt = now
+	}
+	t.advance(height, now)
+}
+
+// peerHeights records the heights discovery reported, with each peer's lag behind the highest
+// peer or the gateway's own reading of the channel
+func (m *networkMonitor) peerHeights(channels []ChannelTopology) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.peers = m.peers[:0]
+	for _, channel := range channels {
+		highest := uint64(0)
+		for _, t := range m.targets {
+			if t.channel == channel.Channel {
+				highest = max(highest, t.height)
+			}
+		}
+		for _, peer := range channel.Peers {
+			highest = max(highest, peer.LedgerHeight)
+		}
+		for _, peer := range channel.Peers {
+			m.peers = append(m.peers, PeerHeight{
+				Channel: channel.Channel, Endpoint: peer.Endpoint, MSPID: peer.MSPID,
+				LedgerHeight: peer.LedgerHeight, Lag: highest - peer.LedgerHeight,
+			})
+		}
+	}
+}
+
+// stalled reports whether a target's ledger has stopped advancing: a transaction submitted
+// FABRIC_STALL_AFTER ago has no block yet, or its height could not be read for that long. A quiet
+// ledger is not stalled, since Fabric only cuts blocks for transactions.
+func (m *networkMonitor) stalled(t *targetMonitor, now time.Time) (string, bool) {
+	if !t.failingSince.IsZero() && now.Sub(t.failingSince) >= m.stallAfter {
+		return fmt.Sprintf("ledger height unreadable for %s: %s", now.Sub(t.failingSince).Round(time.Second), t.sampleErr), true
+	}
+	if !t.pendingSince.IsZero() && now.Sub(t.pendingSince) >= m.stallAfter {
+		return fmt.Sprintf("no new block for %s since a transaction was submitted; height stuck at %d", now.Sub(t.pendingSince).Round(time.Second), t.height), true
+	}
+	return "", false
+}
+
+// recent sums the calls of the buckets within the error window
+func (m *networkMonitor) recent(now time.Time) CallWindow {
+	window := CallWindow{Window: m.window.String()}
+	oldest := now.Add(-m.window).Unix() / 60
+	for _, bucket := range m.buckets {
+		if bucket.minute > oldest && bucket.minute <= now.Unix()/60 {
+			window.Calls += bucket.calls
+			window.Faults += bucket.faults
+		}
+	}
+	if window.Calls > 0 {
+		window.ErrorRate = math.Round(float64(window.Faults)/float64(window.Calls)*1000) / 1000
+	}
+	return window
+}
+
+// conditions lists the alerts that hold now, by key; callers hold mu
+func (m *networkMonitor) conditions(now time.Time) map[string]FabricAlert {
+	held := map[string]FabricAlert{}
+	for name, t := range m.targets {
+		if message, ok := m.stalled(t, now); ok {
+			held[alertLedgerStalled+":"+name] = FabricAlert{Kind: alertLedgerStalled, Target: name, Message: message}
+		}
+		if !t.listening {
+			held[alertListenerDown+":"+name] = FabricAlert{Kind: alertListenerDown, Target: name, Message: "chaincode event listener is not running"}
+		}
+	}
+	for _, peer := range m.peers {
+		if peer.Lag >= m.peerLag {
+			held[alertPeerLag+":"+peer.Channel+":"+peer.Endpoint] = FabricAlert{Kind: alertPeerLag, Peer: peer.Endpoint,
+				Message: fmt.Sprintf("peer %s is %d blocks behind on %s", peer.Endpoint, peer.Lag, peer.Channel)}
+		}
+	}
+	if window := m.recent(now); window.Calls >= m.minCalls && window.ErrorRate >= m.errorRate {
+		held[alertErrorRate] = FabricAlert{Kind: alertErrorRate,
+			Message: fmt.Sprintf("%d of %d chaincode calls failed in the last %s", window.Faults, window.Calls, m.window)}
+	}
+	return held
+}
+
+// evaluate raises the alerts that started holding and resolves those that stopped, returning the
+// changes to notify. An alert that keeps holding keeps its start time.
+func (m *networkMonitor) evaluate(now time.Time) []FabricAlertNotification {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stamp := now.UTC().Format(time.RFC3339)
+	held := m.conditions(now)
+	var changes []FabricAlertNotification
+	for _, key := range sortedKeys(held) {
+		alert := held[key]
+		alert.Key = key
+		if current, ok := m.alerts[key]; ok {
+			alert.Since = current.Since
+		} else {
+			alert.Since = stamp
+			changes = append(changes, FabricAlertNotification{FabricAlert: alert, State: "raised"})
+		}
+		m.alerts[key] = alert
+	}
+	for _, key := range sortedKeys(m.alerts) {
+		if _, ok := held[key]; ok {
+			continue
+		}
+		alert := m.alerts[key]
+		alert.ResolvedAt = stamp
+		delete(m.alerts, key)
+		m.resolved = append(m.resolved, alert)
+		changes = append(changes, FabricAlertNotification{FabricAlert: alert, State: "resolved"})
+	}
+	if len(m.resolved) > monitorResolvedKept {
+		m.resolved = m.resolved[len(m.resolved)-monitorResolvedKept:]
+	}
+	return changes
+}
+
+// run samples every target's height and the discovered peers every FABRIC_MONITOR_INTERVAL and
+// notifies alert changes
+func (m *networkMonitor) run(ctx context.Context) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.sample(ctx)
+			for _, change := range m.evaluate(time.Now()) {
+				m.notify(ctx, change)
+			}
+		}
+	}
+}
+
+func (m *networkMonitor) sample(ctx context.Context) {
+	for _, target := range sortedTargets() {
+		sampleCtx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+		height, err := channelHeight(sampleCtx, target)
+		cancel()
+		m.sampled(target.Name, height, err, time.Now())
+	}
+	if peerDiscoverer != nil {
+		m.peerHeights(peerDiscoverer.snapshot().Channels)
+	}
+}
+
+func (m *networkMonitor) notify(ctx context.Context, change FabricAlertNotification) {
+	log.Printf("🩺 Fabric alert %s: %s", change.State, change.Message)
+	if m.alerter != nil {
+		if err := m.alerter.post(ctx, m.alerter.gatewayURL, change); err != nil {
+			log.Printf("🩺 Failed to post Fabric alert %s: %v", change.Key, err)
+		}
+	}
+	if m.alertEmail != "" && emailer != nil {
+		subject := fmt.Sprintf("[%s] Fabric %s", strings.ToUpper(change.State), strings.ReplaceAll(change.Kind, "_", " "))
+		body := change.Message + "\n\nSince: " + change.Since + "\n"
+		if err := emailer.mailer.send(ctx, emailMessage{to: m.alertEmail, subject: subject, body: body}); err != nil {
+			log.Printf("🩺 Failed to email Fabric alert %s: %v", change.Key, err)
+		}
+	}
+}
+
+// health reports what the monitor knows, without querying the network
+func (m *networkMonitor) health(now time.Time) FabricNetworkHealth {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	report := FabricNetworkHealth{
+		CheckedAt:    now.UTC().Format(time.RFC3339),
+		Circuit:      fabricBreaker.currentState(),
+		Targets:      []TargetHealth{},
+		Recent:       m.recent(now),
+		Transactions: []TransactionCalls{},
+		Alerts:       []FabricAlert{},
+		Resolved:     append([]FabricAlert{}, m.resolved...),
+	}
+	for _, name := range sortedKeys(m.targets) {
+		t := m.targets[name]
+		_, stalled := m.stalled(t, now)
+		health := TargetHealth{
+			Name: name, Channel: t.channel, LedgerHeight: t.height, SampleError: t.sampleErr, Stalled: stalled,
+			HeightChangedAt: formatMonitorTime(t.heightChangedAt),
+			SampledAt:       formatMonitorTime(t.sampledAt),
+			PendingSince:    formatMonitorTime(t.pendingSince),
+			CommitLatency:   t.commits.summary(),
+			EventListener: EventListenerHealth{
+				Running: t.listening, Events: t.events, LastEventBlock: t.lastEventBlock, LastEventAt: formatMonitorTime(t.lastEventAt),
+			},
+			Peers: []PeerHeight{},
+		}
+		for _, peer := range m.peers {
+			if peer.Channel == t.channel {
+				health.Peers = append(health.Peers, peer)
+			}
+		}
+		report.Targets = append(report.Targets, health)
+	}
+	for key, codes := range m.calls {
+		calls := TransactionCalls{Kind: key.kind, Transaction: key.transaction, Errors: map[string]uint64{}}
+		for code, n := range codes {
+			calls.Calls += n
+			if code != "" {
+				calls.Errors[code] = n
+			}
+		}
+		report.Transactions = append(report.Transactions, calls)
+	}
+	sort.Slice(report.Transactions, func(i, j int) bool {
+		a, b := report.Transactions[i], report.Transactions[j]
+		if a.Transaction != b.Transaction {
+			return a.Transaction < b.Transaction
+		}
+		return a.Kind < b.Kind
+	})
+	for _, key := range sortedKeys(m.alerts) {
+		report.Alerts = append(report.Alerts, m.alerts[key])
+	}
+	return report
+}
+
+func formatMonitorTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// writeMetrics renders the report in the Prometheus text exposition format
+func (m *networkMonitor) writeMetrics(b *strings.Builder, report FabricNetworkHealth) {
+	gauge := func(name, help string) {
+		fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+	}
+	counter := func(name, help string) {
+		fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+	}
+	boolValue := func(v bool) int {
+		if v {
+			return 1
+		}
+		return 0
+	}
+
+	gauge("sih_fabric_ledger_height", "Ledger height of each target's channel, as last seen.")
+	for _, t := range report.Targets {
+		fmt.Fprintf(b, "sih_fabric_ledger_height{target=%s,channel=%s} %d\n", metricLabel(t.Name), metricLabel(t.Channel), t.LedgerHeight)
+	}
+	gauge("sih_fabric_ledger_stalled", "1 when a submitted transaction has waited FABRIC_STALL_AFTER for a block.")
+	for _, t := range report.Targets {
+		fmt.Fprintf(b, "sih_fabric_ledger_stalled{target=%s} %d\n", metricLabel(t.Name), boolValue(t.Stalled))
+	}
+	gauge("sih_fabric_peer_ledger_height", "Ledger height discovery reports for each peer.")
+	for _, t := range report.Targets {
+		for _, p := range t.Peers {
+			fmt.Fprintf(b, "sih_fabric_peer_ledger_height{target=%s,channel=%s,peer=%s,msp=%s} %d\n", metricLabel(t.Name), metricLabel(p.Channel), metricLabel(p.Endpoint), metricLabel(p.MSPID), p.LedgerHeight)
+		}
+	}
+	gauge("sih_fabric_peer_block_lag", "Blocks each peer trails the highest peer on its channel.")
+	for _, t := range report.Targets {
+		for _, p := range t.Peers {
+			fmt.Fprintf(b, "sih_fabric_peer_block_lag{target=%s,channel=%s,peer=%s,msp=%s} %d\n", metricLabel(t.Name), metricLabel(p.Channel), metricLabel(p.Endpoint), metricLabel(p.MSPID), p.Lag)
+		}
+	}
+	gauge("sih_fabric_event_listener_up", "1 while the target's chaincode event listener is running.")
+	for _, t := range report.Targets {
+		fmt.Fprintf(b, "sih_fabric_event_listener_up{target=%s} %d\n", metricLabel(t.Name), boolValue(t.EventListener.Running))
+	}
+	counter("sih_fabric_events_total", "Chaincode events received on each target.")
+	for _, t := range report.Targets {
+		fmt.Fprintf(b, "sih_fabric_events_total{target=%s} %d\n", metricLabel(t.Name), t.EventListener.Events)
+	}
+
+	fmt.Fprintf(b, "# HELP sih_fabric_commit_latency_seconds Time from submitting to the orderer until commit.\n# TYPE sih_fabric_commit_latency_seconds histogram\n")
+	m.mu.Lock()
+	for _, name := range sortedKeys(m.targets) {
+		h := m.targets[name].commits
+		var cumulative uint64
+		for i, bound := range commitLatencyBuckets {
+			if h.counts != nil {
+				cumulative += h.counts[i]
+			}
+			fmt.Fprintf(b, "sih_fabric_commit_latency_seconds_bucket{target=%s,le=\"%g\"} %d\n", metricLabel(name), bound, cumulative)
+		}
+		fmt.Fprintf(b, "sih_fabric_commit_latency_seconds_bucket{target=%s,le=\"+Inf\"} %d\n", metricLabel(name), h.count)
+		fmt.Fprintf(b, "sih_fabric_commit_latency_seconds_sum{target=%s} %g\n", metricLabel(name), h.sum)
+		fmt.Fprintf(b, "sih_fabric_commit_latency_seconds_count{target=%s} %d\n", metricLabel(name), h.count)
+	}
+	m.mu.Unlock()
+
+	counter("sih_fabric_calls_total", "Chaincode evaluations and submissions made by the gateway.")
+	for _, calls := range report.Transactions {
+		fmt.Fprintf(b, "sih_fabric_calls_total{kind=%s,transaction=%s} %d\n", metricLabel(calls.Kind), metricLabel(calls.Transaction), calls.Calls)
+	}
+	counter("sih_fabric_call_errors_total", "Failed chaincode calls by error code.")
+	for _, calls := range report.Transactions {
+		for _, code := range sortedKeys(calls.Errors) {
+			fmt.Fprintf(b, "sih_fabric_call_errors_total{kind=%s,transaction=%s,code=%s} %d\n", metricLabel(calls.Kind), metricLabel(calls.Transaction), metricLabel(code), calls.Errors[code])
+		}
+	}
+	gauge("sih_fabric_circuit_open", "1 while the Fabric circuit breaker rejects calls.")
+	fmt.Fprintf(b, "sih_fabric_circuit_open %d\n", boolValue(report.Circuit == breakerOpen))
+	gauge("sih_fabric_alerts_active", "Active network alerts by kind.")
+	active := map[string]int{alertLedgerStalled: 0, alertPeerLag: 0, alertListenerDown: 0, alertErrorRate: 0}
+	for _, alert := range report.Alerts {
+		active[alert.Kind]++
+	}
+	for _, kind := range sortedKeys(active) {
+		fmt.Fprintf(b, "sih_fabric_alerts_active{kind=%s} %d\n", metricLabel(kind), active[kind])
+	}
+}
+
+// metricLabel quotes a label value for the exposition format
+func metricLabel(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value) + `"`
+}
+
+func getNetworkHealth(c *gin.Context) {
+	respondData(c, http.StatusOK, fabricMonitor.health(time.Now()))
+}
+
+// metricsHandler serves the monitor's metrics to Prometheus. With METRICS_TOKEN set, scrapers
+// must send it as a bearer token.
+func metricsHandler(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token != "" {
+			presented, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(presented), []byte(token)) != 1 {
+				c.AbortWithStatus(http.StatusUnauthorized)
+				return
+			}
+		}
+		var b strings.Builder
+		fabricMonitor.writeMetrics(&b, fabricMonitor.health(time.Now()))
+		if canary != nil {
+			canary.writeMetrics(&b)
+		}
+		c.Data(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", []byte(b.String()))
+	}
+}
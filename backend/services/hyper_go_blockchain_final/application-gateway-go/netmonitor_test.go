@@ -0,0 +1,138 @@
+package gateway
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestNetworkMonitorStall(t *testing.T) {
+	m := newNetworkMonitor()
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	m.listening("main", true)
+	m.sampled("main", 40, nil, now)
+
+	// A quiet ledger is not stalled
+	if changes := m.evaluate(now.Add(time.Hour)); len(changes) != 0 {
+		t.Fatalf("expected no alerts for an idle ledger, got %+v", changes)
+	}
+
+	m.submitted("main", now.Add(time.Hour))
+	if changes := m.evaluate(now.Add(time.Hour + m.stallAfter - time.Second)); len(changes) != 0 {
+		t.Fatalf("expected no alert before FABRIC_STALL_AFTER, got %+v", changes)
+	}
+	stalledAt := now.Add(time.Hour + m.stallAfter)
+	changes := m.evaluate(stalledAt)
+	if len(changes) != 1 || changes[0].Kind != alertLedgerStalled || changes[0].State != "raised" {
+		t.Fatalf("expected the stall raised, got %+v", changes)
+	}
+	if changes := m.evaluate(stalledAt.Add(time.Minute)); len(changes) != 0 {
+		t.Errorf("expected a held alert not raised again, got %+v", changes)
+	}
+
+	m.committed("main", 40, 2*time.Second, stalledAt.Add(2*time.Minute))
+	changes = m.evaluate(stalledAt.Add(2 * time.Minute))
+	if len(changes) != 1 || changes[0].State != "resolved" || changes[0].Since != stalledAt.Format(time.RFC3339) {
+		t.Fatalf("expected the stall resolved once a block committed, got %+v", changes)
+	}
+	report := m.health(stalledAt.Add(2 * time.Minute))
+	if target := report.Targets[0]; target.LedgerHeight != 41 || target.Stalled || target.CommitLatency.Count != 1 {
+		t.Errorf("expected the commit to advance the height, got %+v", target)
+	}
+	if len(report.Resolved) != 1 {
+		t.Errorf("expected the resolved alert kept, got %+v", report.Resolved)
+	}
+
+	m.sampled("main", 0, errors.New("connection refused"), stalledAt.Add(3*time.Minute))
+	if changes := m.evaluate(stalledAt.Add(3*time.Minute + m.stallAfter)); len(changes) != 1 || changes[0].Kind != alertLedgerStalled {
+		t.Errorf("expected an unreadable ledger to count as stalled, got %+v", changes)
+	}
+}
+
+func TestNetworkMonitorPeersAndListener(t *testing.T) {
+	m := newNetworkMonitor()
+	now := time.Now()
+	m.listening("main", true)
+	m.target("main").channel = "mychannel"
+	m.sampled("main", 120, nil, now)
+	m.peerHeights([]ChannelTopology{{Channel: "mychannel", Peers: []DiscoveredPeer{
+		{Endpoint: "peer0.org1.example.com:7051", MSPID: "Org1MSP", LedgerHeight: 118},
+		{Endpoint: "peer0.org2.example.com:9051", MSPID: "Org2MSP", LedgerHeight: 100},
+	}}})
+	m.listening("main", false)
+
+	changes := m.evaluate(now)
+	kinds := map[string]string{}
+	for _, change := range changes {
+		kinds[change.Kind] = change.Peer
+	}
+	if len(changes) != 2 || kinds[alertPeerLag] != "peer0.org2.example.com:9051" {
+		t.Errorf("expected the lagging peer only, got %+v", changes)
+	}
+	if _, ok := kinds[alertListenerDown]; !ok {
+		t.Errorf("expected the stopped listener raised, got %+v", changes)
+	}
+	if peers := m.health(now).Targets[0].Peers; len(peers) != 2 || peers[0].Lag != 2 || peers[1].Lag != 20 {
+		t.Errorf("expected lags against the gateway's height, got %+v", peers)
+	}
+}
+
+func TestNetworkMonitorErrorRate(t *testing.T) {
+	m := newNetworkMonitor()
+	now := time.Now()
+	for i := range 30 {
+		var err error
+		if i%2 == 0 {
+			err = status.Error(codes.Unavailable, "peer down")
+		}
+		m.recordCall(callSubmit, "CreateIncident", err, now)
+	}
+	for range 10 {
+		m.recordCall(callEvaluate, "ReadIncident", errors.New("the incident INC-1 does not exist"), now)
+	}
+
+	report := m.health(now)
+	if report.Recent.Calls != 40 || report.Recent.Faults != 15 {
+		t.Fatalf("expected refusals not counted as faults, got %+v", report.Recent)
+	}
+	if calls := report.Transactions[0]; calls.Transaction != "CreateIncident" || calls.Calls != 30 || calls.Errors[errCodeUnavailable] != 15 {
+		t.Errorf("unexpected counts %+v", calls)
+	}
+	changes := m.evaluate(now)
+	if len(changes) != 1 || changes[0].Kind != alertErrorRate {
+		t.Errorf("expected the error rate raised, got %+v", changes)
+	}
+	if window := m.recent(now.Add(m.window + time.Minute)); window.Calls != 0 {
+		t.Errorf("expected old calls outside the window, got %+v", window)
+	}
+
+	var b strings.Builder
+	m.writeMetrics(&b, report)
+	for _, line := range []string{
+		`sih_fabric_calls_total{kind="submit",transaction="CreateIncident"} 30`,
+		`sih_fabric_call_errors_total{kind="evaluate",transaction="ReadIncident",code="NOT_FOUND"} 10`,
+		`sih_fabric_alerts_active{kind="chaincode_error_rate"} 0`,
+	} {
+		if !strings.Contains(b.String(), line+"\n") {
+			t.Errorf("expected %s in the metrics", line)
+		}
+	}
+}
+
+func TestLatencyHistogram(t *testing.T) {
+	var h latencyHistogram
+	for _, seconds := range []float64{0.1, 0.3, 0.4, 0.8, 1.5, 1.6, 1.7, 1.8, 3, 45} {
+		h.observe(seconds)
+	}
+	summary := h.summary()
+	if summary.Count != 10 || summary.AvgMS != 5620 {
+		t.Errorf("unexpected summary %+v", summary)
+	}
+	if summary.P50MS < 1000 || summary.P50MS > 2000 || summary.P95MS < 30000 || summary.P95MS > 60000 {
+		t.Errorf("expected the percentiles within their buckets, got %+v", summary)
+	}
+}
@@ -0,0 +1,1214 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hyperledger/fabric-gateway/pkg/identity"
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"github.com/hyperledger/fabric-protos-go-apiv2/msp"
+	"github.com/hyperledger/fabric-protos-go-apiv2/orderer"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// networkCommands are the sih-network steps in the order "up" runs them
+var networkCommands = []string{"profiles", "create", "join", "anchors", "verify"}
+
+// channelNameRegex is Fabric's rule for channel names
+var channelNameRegex = regexp.MustCompile(`^[a-z][a-z0-9.-]{0,248}$`)
+
+// networkTopology describes the channel sih-network stands up. Relative paths are resolved
+// against the directory of the topology file.
+type networkTopology struct {
+	Channel   string            `json:"channel"`
+	Profile   string            `json:"profile"`
+	ConfigTx  string            `json:"configtx"`
+	Block     string            `json:"block"`
+	Bin       string            `json:"bin,omitempty"`
+	Orderers  []networkOrderer  `json:"orderers"`
+	Orgs      []networkPeerOrg  `json:"orgs"`
+	Chaincode *networkChaincode `json:"chaincode,omitempty"`
+	Backup    *networkBackup    `json:"backup,omitempty"`
+	dir       string
+}
+
+// networkOrderer is an ordering node: Address takes broadcasts, Admin is the channel
+// participation API, which only accepts the client certificate in ClientCert
+type networkOrderer struct {
+	Name       string `json:"name"`
+	Address    string `json:"address"`
+	Admin      string `json:"admin"`
+	TLSCA      string `json:"tlsCA"`
+	ClientCert string `json:"clientCert"`
+	ClientKey  string `json:"clientKey"`
+}
+
+// networkPeerOrg is a peer organization. AdminMSP and TLSCA default to the cryptogen layout
+// under CryptoPath.
+type networkPeerOrg struct {
+	Name       string        `json:"name"`
+	MSPID      string        `json:"mspID"`
+	CryptoPath string        `json:"cryptoPath"`
+	AdminMSP   string        `json:"adminMSP,omitempty"`
+	TLSCA      string        `json:"tlsCA,omitempty"`
+	CA         *networkCA    `json:"ca,omitempty"`
+	Peers      []networkPeer `json:"peers"`
+}
+
+// networkPeer is dialled at Address; Anchor, when set, is the host:port other organizations'
+// peers gossip with, which inside Docker is not the published port
+type networkPeer struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	Anchor  string `json:"anchor,omitempty"`
+}
+
+type networkCA struct {
+	Name    string `json:"name"`
+	CAName  string `json:"caName"`
+	URL     string `json:"url"`
+	TLSCert string `json:"tlsCert,omitempty"`
+}
+
+// loadNetworkTopology reads and checks a topology file, filling in the cryptogen defaults
+func loadNetworkTopology(filename string) (*networkTopology, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read topology: %w", err)
+	}
+	var t networkTopology
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&t); err != nil {
+		return nil, fmt.Errorf("invalid topology %s: %w", filename, err)
+	}
+	t.dir = filepath.Dir(filename)
+	if t.Block == "" {
+		t.Block = filepath.Join("channel-artifacts", t.Channel+".block")
+	}
+	for i := range t.Orgs {
+		org := &t.Orgs[i]
+		domain := filepath.Base(org.CryptoPath)
+		if org.AdminMSP == "" {
+			org.AdminMSP = filepath.Join(org.CryptoPath, "users", "Admin@"+domain, "msp")
+		}
+		if org.TLSCA == "" {
+			org.TLSCA = filepath.Join(org.CryptoPath, "tlsca", "tlsca."+domain+"-cert.pem")
+		}
+		if org.CA != nil && org.CA.TLSCert == "" {
+			org.CA.TLSCert = filepath.Join(org.CryptoPath, "ca", "ca."+domain+"-cert.pem")
+		}
+	}
+	if t.Chaincode != nil {
+		t.Chaincode.setDefaults(&t)
+	}
+	if t.Backup != nil {
+		t.Backup.setDefaults()
+	}
+	if err := t.validate(); err != nil {
+		return nil, fmt.Errorf("invalid topology %s: %w", filename, err)
+	}
+	return &t, nil
+}
+
+func (t *networkTopology) validate() error {
+	switch {
+	case !channelNameRegex.MatchString(t.Channel):
+		return fmt.Errorf("channel %q must be lower case letters, digits, '.' and '-'", t.Channel)
+	case t.Profile == "" || t.ConfigTx == "":
+		return errors.New("profile and configtx are required")
+	case len(t.Orderers) == 0 || len(t.Orgs) == 0:
+		return errors.New("at least one orderer and one organization are required")
+	}
+	for _, o := range t.Orderers {
+		if o.Name == "" || o.Address == "" || o.Admin == "" || o.TLSCA == "" || o.ClientCert == "" || o.ClientKey == "" {
+			return fmt.Errorf("orderer %q needs name, address, admin, tlsCA, clientCert and clientKey", o.Name)
+		}
+	}
+	msps := map[string]bool{}
+	for _, org := range t.Orgs {
+		if org.Name == "" || org.MSPID == "" || org.CryptoPath == "" || len(org.Peers) == 0 {
+			return fmt.Errorf("organization %q needs name, mspID, cryptoPath and at least one peer", org.Name)
+		}
+		if msps[org.MSPID] {
+			return fmt.Errorf("MSP ID %s is listed twice", org.MSPID)
+		}
+		msps[org.MSPID] = true
+		for _, p := range org.Peers {
+			if p.Name == "" || p.Address == "" {
+				return fmt.Errorf("every peer of %s needs a name and address", org.Name)
+			}
+			if p.Anchor != "" {
+				if _, _, err := splitHostPort(p.Anchor); err != nil {
+					return fmt.Errorf("anchor of %s: %w", p.Name, err)
+				}
+			}
+		}
+	}
+	if t.Chaincode != nil {
+		if err := t.Chaincode.validate(); err != nil {
+			return err
+		}
+	}
+	if t.Backup != nil {
+		return t.Backup.validate(t)
+	}
+	return nil
+}
+
+// path resolves a topology path against the topology file's directory
+func (t *networkTopology) path(p string) string {
+	if p == "" || filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(t.dir, p)
+}
+
+// anchors lists the anchor peers an organization declares
+func (org networkPeerOrg) anchors() []*peer.AnchorPeer {
+	var anchors []*peer.AnchorPeer
+	for _, p := range org.Peers {
+		if p.Anchor == "" {
+			continue
+		}
+		host, port, _ := splitHostPort(p.Anchor)
+		anchors = append(anchors, &peer.AnchorPeer{Host: host, Port: int32(port)})
+	}
+	return anchors
+}
+
+func splitHostPort(address string) (string, int, error) {
+	host, portText, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", 0, err
+	}
+	port, err := strconv.Atoi(portText)
+	if err != nil || port < 1 || port > 65535 {
+		return "", 0, fmt.Errorf("invalid port in %s", address)
+	}
+	return host, port, nil
+}
+
+// RunNetwork is the sih-network entry point: each command is idempotent, so "up" and "deploy" can
+// be rerun after a partial failure and only do the steps still missing
+func RunNetwork() {
+	flags := flag.NewFlagSet("sih-network", flag.ExitOnError)
+	topologyFile := flags.String("topology", getEnv("NETWORK_TOPOLOGY", "../test-network/topology.json"), "topology file describing the channel, orderers, organizations and chaincode")
+	retries := flags.Int("retries", 5, "attempts for each join, approval and commit while the nodes catch up")
+	delay := flags.Duration("delay", 3*time.Second, "wait between attempts")
+	dryRun := flags.Bool("dry-run", false, "report what the chaincode commands would change without changing it")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: sih-network [flags] <%s|up>\n", strings.Join(networkCommands, "|"))
+		fmt.Fprintf(flags.Output(), "       sih-network [flags] <%s|deploy|rollback>\n", strings.Join(chaincodeCommands, "|"))
+		flags.PrintDefaults()
+	}
+	flags.Parse(os.Args[1:])
+	command := flags.Arg(0)
+	known := slices.Concat(networkCommands, chaincodeCommands, []string{"up", "deploy", "rollback"})
+	if flags.NArg() != 1 || !slices.Contains(known, command) {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	topology, err := loadNetworkTopology(*topologyFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	b := &networkBootstrap{topology: topology, retries: max(*retries, 1), delay: *delay, dryRun: *dryRun}
+	commands := []string{command}
+	if command == "up" {
+		commands = networkCommands
+	}
+	for _, command := range commands {
+		if err := b.run(ctx, command); err != nil {
+			stop()
+			log.Fatalf("❌ %s failed: %v", command, err)
+		}
+	}
+}
+
+type networkBootstrap struct {
+	topology *networkTopology
+	retries  int
+	delay    time.Duration
+	dryRun   bool
+}
+
+func (b *networkBootstrap) run(ctx context.Context, command string) error {
+	switch command {
+	case "profiles":
+		return b.writeProfiles()
+	case "create":
+		return b.createChannel(ctx)
+	case "join":
+		return b.joinPeers(ctx)
+	case "anchors":
+		return b.setAnchorPeers(ctx)
+	case "verify":
+		return b.verify(ctx)
+	case "deploy":
+		return b.runChaincode(ctx, chaincodeCommands)
+	case "rollback":
+		return b.rollback(ctx)
+	default:
+		return b.runChaincode(ctx, []string{command})
+	}
+}
+
+// retry runs fn until it succeeds, the attempts run out or ctx is done
+func (b *networkBootstrap) retry(ctx context.Context, what string, fn func() error) error {
+	var err error
+	for attempt := 1; attempt <= b.retries; attempt++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+		if attempt < b.retries {
+			log.Printf("⏳ %s failed (attempt %d of %d), retrying in %s: %v", what, attempt, b.retries, b.delay, err)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(b.delay):
+			}
+		}
+	}
+	return err
+}
+
+// writeProfiles writes connection-<org>.json and .yaml into each organization's crypto directory,
+// in the layout of the test network's ccp-generate.sh
+func (b *networkBootstrap) writeProfiles() error {
+	for _, org := range b.topology.Orgs {
+		profile, err := connectionProfile(b.topology, org)
+		if err != nil {
+			return fmt.Errorf("%s: %w", org.Name, err)
+		}
+		jsonData, err := json.MarshalIndent(profile, "", "    ")
+		if err != nil {
+			return err
+		}
+		base := filepath.Join(b.topology.path(org.CryptoPath), "connection-"+strings.ToLower(org.Name))
+		if err := os.WriteFile(base+".json", append(jsonData, '\n'), 0o644); err != nil {
+			return err
+		}
+		if err := os.WriteFile(base+".yaml", profile.yaml(), 0o644); err != nil {
+			return err
+		}
+		log.Printf("📇 Wrote %s.json and .yaml", base)
+	}
+	return nil
+}
+
+// connectionProfile builds an organization's connection profile with its PEMs inlined
+func connectionProfile(t *networkTopology, org networkPeerOrg) (profileMap, error) {
+	peerPEM, err := os.ReadFile(t.path(org.TLSCA))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the peer TLS CA: %w", err)
+	}
+	var peerNames []string
+	var peers profileMap
+	for _, p := range org.Peers {
+		peerNames = append(peerNames, p.Name)
+		peers = append(peers, profileField{p.Name, profileMap{
+			{"url", "grpcs://" + p.Address},
+			{"tlsCACerts", profileMap{{"pem", string(peerPEM)}}},
+			{"grpcOptions", profileMap{{"ssl-target-name-override", p.Name}, {"hostnameOverride", p.Name}}},
+		}})
+	}
+	orgEntry := profileMap{{"mspid", org.MSPID}, {"peers", peerNames}}
+	var cas profileMap
+	if org.CA != nil {
+		caPEM, err := os.ReadFile(t.path(org.CA.TLSCert))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the CA certificate: %w", err)
+		}
+		orgEntry = append(orgEntry, profileField{"certificateAuthorities", []string{org.CA.Name}})
+		cas = profileMap{{org.CA.Name, profileMap{
+			{"url", org.CA.URL},
+			{"caName", org.CA.CAName},
+			{"tlsCACerts", profileMap{{"pem", []string{string(caPEM)}}}},
+			{"httpOptions", profileMap{{"verify", false}}},
+		}}}
+	}
+
+	profile := profileMap{
+		{"name", "test-network-" + strings.ToLower(org.Name)},
+		{"version", "1.0.0"},
+		{"client", profileMap{
+			{"organization", org.Name},
+			{"connection", profileMap{{"timeout", profileMap{{"peer", profileMap{{"endorser", "300"}}}}}}},
+		}},
+		{"organizations", profileMap{{org.Name, orgEntry}}},
+		{"peers", peers},
+	}
+	if cas != nil {
+		profile = append(profile, profileField{"certificateAuthorities", cas})
+	}
+	return profile, nil
+}
+
+// profileMap is a mapping that keeps its key order in both JSON and YAML. Values are strings,
+// booleans, string lists or nested maps.
+type profileMap []profileField
+
+type profileField struct {
+	key   string
+	value interface{}
+}
+
+func (m profileMap) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, field := range m {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(field.key)
+		value, err := json.Marshal(field.value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// yaml renders the map as block YAML, with PEMs as literal blocks
+func (m profileMap) yaml() []byte {
+	var buf bytes.Buffer
+	buf.WriteString("---\n")
+	m.writeYAML(&buf, "")
+	return buf.Bytes()
+}
+
+func (m profileMap) writeYAML(buf *bytes.Buffer, indent string) {
+	for _, field := range m {
+		switch v := field.value.(type) {
+		case profileMap:
+			fmt.Fprintf(buf, "%s%s:\n", indent, field.key)
+			v.writeYAML(buf, indent+"  ")
+		case []string:
+			fmt.Fprintf(buf, "%s%s:\n", indent, field.key)
+			for _, item := range v {
+				if strings.Contains(item, "\n") {
+					fmt.Fprintf(buf, "%s- |\n", indent)
+					writeYAMLBlock(buf, item, indent+"  ")
+				} else {
+					fmt.Fprintf(buf, "%s- %s\n", indent, yamlScalar(item))
+				}
+			}
+		case string:
+			if strings.Contains(v, "\n") {
+				fmt.Fprintf(buf, "%s%s: |\n", indent, field.key)
+				writeYAMLBlock(buf, v, indent+"  ")
+			} else {
+				fmt.Fprintf(buf, "%s%s: %s\n", indent, field.key, yamlScalar(v))
+			}
+		default:
+			fmt.Fprintf(buf, "%s%s: %v\n", indent, field.key, v)
+		}
+	}
+}
+
+func writeYAMLBlock(buf *bytes.Buffer, text, indent string) {
+	for _, line := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+		fmt.Fprintf(buf, "%s%s\n", indent, line)
+	}
+}
+
+// yamlScalar quotes strings YAML would otherwise read as another type or as structure
+func yamlScalar(s string) string {
+	_, numberErr := strconv.ParseFloat(s, 64)
+	switch {
+	case s == "", numberErr == nil, slices.Contains([]string{"true", "false", "null", "yes", "no", "~"}, strings.ToLower(s)),
+		strings.ContainsAny(s[:1], "-?:,[]{}#&*!|>'\"%@`"), strings.Contains(s, ": "), strings.Contains(s, " #"):
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// createChannel generates the channel's genesis block with configtxgen, unless it exists, and joins
+// each orderer through its channel participation API
+func (b *networkBootstrap) createChannel(ctx context.Context) error {
+	t := b.topology
+	var pending []networkOrderer
+	for _, o := range t.Orderers {
+		status, err := ordererChannelStatus(ctx, t, o)
+		if err != nil {
+			return fmt.Errorf("%s: %w", o.Name, err)
+		}
+		if status != nil {
+			log.Printf("⏭️  %s already serves %s (%s, %s)", o.Name, t.Channel, status.ConsensusRelation, status.Status)
+			continue
+		}
+		pending = append(pending, o)
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	block := t.path(t.Block)
+	if _, err := os.Stat(block); errors.Is(err, os.ErrNotExist) {
+		if err := b.generateBlock(ctx, block); err != nil {
+			return err
+		}
+	}
+	blockData, err := os.ReadFile(block)
+	if err != nil {
+		return fmt.Errorf("failed to read the channel block: %w", err)
+	}
+	for _, o := range pending {
+		if err := b.retry(ctx, "Joining "+o.Name, func() error { return joinOrderer(ctx, t, o, blockData) }); err != nil {
+			return fmt.Errorf("%s: %w", o.Name, err)
+		}
+		log.Printf("⛓️  %s joined %s", o.Name, t.Channel)
+	}
+	return nil
+}
+
+func (b *networkBootstrap) generateBlock(ctx context.Context, block string) error {
+	t := b.topology
+	configtxgen := "configtxgen"
+	if t.Bin != "" {
+		configtxgen = filepath.Join(t.path(t.Bin), configtxgen)
+	}
+	if err := os.MkdirAll(filepath.Dir(block), 0o755); err != nil {
+		return err
+	}
+	cmd := exec.CommandContext(ctx, configtxgen, "-profile", t.Profile, "-outputBlock", block, "-channelID", t.Channel)
+	cmd.Env = append(os.Environ(), "FABRIC_CFG_PATH="+t.path(t.ConfigTx))
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("configtxgen failed: %w\n%s", err, output)
+	}
+	log.Printf("🧱 Generated %s with profile %s", block, t.Profile)
+	return nil
+}
+
+// ordererChannel is a channel as the channel participation API reports it
+type ordererChannel struct {
+	Name              string `json:"name"`
+	ConsensusRelation string `json:"consensusRelation"`
+	Status            string `json:"status"`
+	Height            uint64 `json:"height"`
+}
+
+// ordererAdminClient authenticates to an orderer's admin endpoint with mutual TLS
+func ordererAdminClient(t *networkTopology, o networkOrderer) (*http.Client, error) {
+	ca, err := loadCertificate(t.path(o.TLSCA))
+	if err != nil {
+		return nil, err
+	}
+	clientCert, err := tls.LoadX509KeyPair(t.path(o.ClientCert), t.path(o.ClientKey))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the orderer admin client certificate: %w", err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	return &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			MinVersion:   tls.VersionTLS12,
+			RootCAs:      roots,
+			ServerName:   o.Name,
+			Certificates: []tls.Certificate{clientCert},
+		}},
+	}, nil
+}
+
+// ordererChannelStatus returns the orderer's view of the channel, or nil if it has not joined
+func ordererChannelStatus(ctx context.Context, t *networkTopology, o networkOrderer) (*ordererChannel, error) {
+	client, err := ordererAdminClient(t, o)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://"+o.Admin+"/participation/v1/channels/"+t.Channel, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return nil, nil
+	case http.StatusOK:
+		var status ordererChannel
+		if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+			return nil, fmt.Errorf("invalid channel status: %w", err)
+		}
+		return &status, nil
+	}
+	return nil, participationError(resp)
+}
+
+// joinOrderer posts the genesis block as the config-block part, as osnadmin channel join does
+func joinOrderer(ctx context.Context, t *networkTopology, o networkOrderer, block []byte) error {
+	client, err := ordererAdminClient(t, o)
+	if err != nil {
+		return err
+	}
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("config-block", t.Channel+".block")
+	if err != nil {
+		return err
+	}
+	part.Write(block)
+	form.Close()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://"+o.Admin+"/participation/v1/channels", &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return participationError(resp)
+	}
+	return nil
+}
+
+func participationError(resp *http.Response) error {
+	var problem struct {
+		Error string `json:"error"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if json.Unmarshal(data, &problem) == nil && problem.Error != "" {
+		return fmt.Errorf("channel participation API returned %d: %s", resp.StatusCode, problem.Error)
+	}
+	return fmt.Errorf("channel participation API returned %d", resp.StatusCode)
+}
+
+// networkAdmin signs as an organization's admin, the identity peers require for JoinChain and the
+// channel configuration requires for anchor peer updates
+type networkAdmin struct {
+	creator []byte
+	sign    identity.Sign
+}
+
+func loadNetworkAdmin(t *networkTopology, org networkPeerOrg) (*networkAdmin, error) {
+	mspDir := t.path(org.AdminMSP)
+	certificatePEM, err := readFirstFile(filepath.Join(mspDir, "signcerts"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the %s admin certificate: %w", org.Name, err)
+	}
+	privateKeyPEM, err := readFirstFile(filepath.Join(mspDir, "keystore"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the %s admin key: %w", org.Name, err)
+	}
+	privateKey, err := identity.PrivateKeyFromPEM(privateKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+	sign, err := identity.NewPrivateKeySign(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	creator, err := proto.Marshal(&msp.SerializedIdentity{Mspid: org.MSPID, IdBytes: certificatePEM})
+	if err != nil {
+		return nil, err
+	}
+	return &networkAdmin{creator: creator, sign: sign}, nil
+}
+
+// signatureHeader returns a fresh header and the transaction ID Fabric derives from it
+func (a *networkAdmin) signatureHeader() ([]byte, string, error) {
+	nonce := make([]byte, 24)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, "", err
+	}
+	txID := sha256.Sum256(append(slices.Clone(nonce), a.creator...))
+	header, err := proto.Marshal(&common.SignatureHeader{Creator: a.creator, Nonce: nonce})
+	return header, hex.EncodeToString(txID[:]), err
+}
+
+func (a *networkAdmin) signDigest(message ...[]byte) ([]byte, error) {
+	digest := sha256.New()
+	for _, m := range message {
+		digest.Write(m)
+	}
+	return a.sign(digest.Sum(nil))
+}
+
+// dialPeer opens a TLS connection to a peer of the organization
+func dialPeer(t *networkTopology, org networkPeerOrg, p networkPeer) (*grpc.ClientConn, error) {
+	return dialNode(t.path(org.TLSCA), p.Address, p.Name)
+}
+
+func dialNode(caFile, address, serverName string) (*grpc.ClientConn, error) {
+	ca, err := loadCertificate(caFile)
+	if err != nil {
+		return nil, err
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	return grpc.NewClient("dns:///"+address, grpc.WithTransportCredentials(credentials.NewClientTLSFromCert(roots, serverName)))
+}
+
+// signedProposal is a chaincode proposal signed by an organization admin, kept with its header so
+// the endorsements can be assembled into a transaction
+type signedProposal struct {
+	header   *common.Header
+	proposal *peer.Proposal
+	signed   *peer.SignedProposal
+}
+
+// newSignedProposal builds a proposal to invoke a chaincode on the channel, or on the peer itself
+// when channel is empty
+func newSignedProposal(admin *networkAdmin, channel, chaincode string, args ...[]byte) (*signedProposal, error) {
+	spec := &peer.ChaincodeSpec{
+		Type:        peer.ChaincodeSpec_GOLANG,
+		ChaincodeId: &peer.ChaincodeID{Name: chaincode},
+		Input:       &peer.ChaincodeInput{Args: args},
+	}
+	input, err := proto.Marshal(&peer.ChaincodeInvocationSpec{ChaincodeSpec: spec})
+	if err != nil {
+		return nil, err
+	}
+	payload, err := proto.Marshal(&peer.ChaincodeProposalPayload{Input: input})
+	if err != nil {
+		return nil, err
+	}
+	extension, err := proto.Marshal(&peer.ChaincodeHeaderExtension{ChaincodeId: spec.ChaincodeId})
+	if err != nil {
+		return nil, err
+	}
+	signatureHeader, txID, err := admin.signatureHeader()
+	if err != nil {
+		return nil, err
+	}
+	channelHeader, err := proto.Marshal(&common.ChannelHeader{
+		Type:      int32(common.HeaderType_ENDORSER_TRANSACTION),
+		ChannelId: channel,
+		TxId:      txID,
+		Timestamp: timestamppb.Now(),
+		Extension: extension,
+	})
+	if err != nil {
+		return nil, err
+	}
+	header := &common.Header{ChannelHeader: channelHeader, SignatureHeader: signatureHeader}
+	headerBytes, err := proto.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+	proposal := &peer.Proposal{Header: headerBytes, Payload: payload}
+	proposalBytes, err := proto.Marshal(proposal)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := admin.signDigest(proposalBytes)
+	if err != nil {
+		return nil, err
+	}
+	return &signedProposal{header: header, proposal: proposal, signed: &peer.SignedProposal{ProposalBytes: proposalBytes, Signature: signature}}, nil
+}
+
+// processProposal sends the proposal to a peer and fails unless the chaincode succeeded
+func processProposal(ctx context.Context, conn grpc.ClientConnInterface, sp *signedProposal, timeout time.Duration) (*peer.ProposalResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	resp, err := peer.NewEndorserClient(conn).ProcessProposal(ctx, sp.signed)
+	if err != nil {
+		return nil, err
+	}
+	if status := resp.GetResponse().GetStatus(); status != 200 {
+		return nil, fmt.Errorf("returned %d: %s", status, resp.GetResponse().GetMessage())
+	}
+	return resp, nil
+}
+
+// invokeSystemChaincode evaluates a system chaincode function as the organization admin and
+// returns the response payload
+func invokeSystemChaincode(ctx context.Context, conn grpc.ClientConnInterface, admin *networkAdmin, channel, chaincode string, args ...[]byte) ([]byte, error) {
+	sp, err := newSignedProposal(admin, channel, chaincode, args...)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := processProposal(ctx, conn, sp, time.Minute)
+	if err != nil {
+		return nil, fmt.Errorf("%s %w", args[0], err)
+	}
+	return resp.GetResponse().GetPayload(), nil
+}
+
+// invokeCSCC calls the peer's configuration system chaincode
+func invokeCSCC(ctx context.Context, conn grpc.ClientConnInterface, admin *networkAdmin, args ...[]byte) ([]byte, error) {
+	return invokeSystemChaincode(ctx, conn, admin, "", "cscc", args...)
+}
+
+// peerChannels lists the channels a peer has joined
+func peerChannels(ctx context.Context, conn grpc.ClientConnInterface, admin *networkAdmin) ([]string, error) {
+	payload, err := invokeCSCC(ctx, conn, admin, []byte("GetChannels"))
+	if err != nil {
+		return nil, err
+	}
+	var channels peer.ChannelQueryResponse
+	if err := proto.Unmarshal(payload, &channels); err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, channel := range channels.GetChannels() {
+		names = append(names, channel.GetChannelId())
+	}
+	return names, nil
+}
+
+// channelConfig reads the channel's current configuration from a peer
+func channelConfig(ctx context.Context, conn grpc.ClientConnInterface, admin *networkAdmin, channel string) (*common.Config, error) {
+	payload, err := invokeCSCC(ctx, conn, admin, []byte("GetChannelConfig"), []byte(channel))
+	if err != nil {
+		return nil, err
+	}
+	var config common.Config
+	if err := proto.Unmarshal(payload, &config); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// joinPeers joins every peer not yet on the channel to it, from the genesis block create wrote
+func (b *networkBootstrap) joinPeers(ctx context.Context) error {
+	t := b.topology
+	var block []byte
+	for _, org := range t.Orgs {
+		admin, err := loadNetworkAdmin(t, org)
+		if err != nil {
+			return err
+		}
+		for _, p := range org.Peers {
+			conn, err := dialPeer(t, org, p)
+			if err != nil {
+				return fmt.Errorf("%s: %w", p.Name, err)
+			}
+			err = b.retry(ctx, "Joining "+p.Name, func() error {
+				channels, err := peerChannels(ctx, conn, admin)
+				if err != nil {
+					return err
+				}
+				if slices.Contains(channels, t.Channel) {
+					log.Printf("⏭️  %s is already on %s", p.Name, t.Channel)
+					return nil
+				}
+				if block == nil {
+					if block, err = os.ReadFile(t.path(t.Block)); err != nil {
+						return fmt.Errorf("failed to read the channel block, run create first: %w", err)
+					}
+				}
+				if _, err := invokeCSCC(ctx, conn, admin, []byte("JoinChain"), block); err != nil {
+					return err
+				}
+				log.Printf("🔗 %s joined %s", p.Name, t.Channel)
+				return nil
+			})
+			conn.Close()
+			if err != nil {
+				return fmt.Errorf("%s: %w", p.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// setAnchorPeers submits a configuration update per organization whose anchor peers differ from
+// the topology, signed by that organization's admin as the Admins mod policy requires
+func (b *networkBootstrap) setAnchorPeers(ctx context.Context) error {
+	t := b.topology
+	ord := t.Orderers[0]
+	ordererConn, err := dialNode(t.path(ord.TLSCA), ord.Address, ord.Name)
+	if err != nil {
+		return err
+	}
+	defer ordererConn.Close()
+
+	for _, org := range t.Orgs {
+		anchors := org.anchors()
+		if len(anchors) == 0 {
+			continue
+		}
+		admin, err := loadNetworkAdmin(t, org)
+		if err != nil {
+			return err
+		}
+		conn, err := dialPeer(t, org, org.Peers[0])
+		if err != nil {
+			return err
+		}
+		current, err := channelConfig(ctx, conn, admin, t.Channel)
+		conn.Close()
+		if err != nil {
+			return fmt.Errorf("%s: failed to read the channel configuration: %w", org.Name, err)
+		}
+		updated, err := withAnchorPeers(current, org.MSPID, anchors)
+		if err != nil {
+			return err
+		}
+		update, err := computeConfigUpdate(t.Channel, current, updated)
+		if errors.Is(err, errConfigUnchanged) {
+			log.Printf("⏭️  %s anchor peers are already set", org.Name)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		envelope, err := configUpdateEnvelope(t.Channel, update, admin)
+		if err != nil {
+			return err
+		}
+		if err := broadcast(ctx, ordererConn, envelope); err != nil {
+			return fmt.Errorf("%s: anchor peer update: %w", org.Name, err)
+		}
+		log.Printf("⚓ Set the %s anchor peers on %s", org.Name, t.Channel)
+	}
+	return nil
+}
+
+// withAnchorPeers returns a copy of the configuration with the organization's anchor peers replaced
+func withAnchorPeers(config *common.Config, mspID string, anchors []*peer.AnchorPeer) (*common.Config, error) {
+	updated := proto.Clone(config).(*common.Config)
+	application := updated.GetChannelGroup().GetGroups()["Application"]
+	org := application.GetGroups()[mspID]
+	if org == nil {
+		return nil, fmt.Errorf("%s is not an application organization of the channel", mspID)
+	}
+	value, err := proto.Marshal(&peer.AnchorPeers{AnchorPeers: anchors})
+	if err != nil {
+		return nil, err
+	}
+	if org.Values == nil {
+		org.Values = map[string]*common.ConfigValue{}
+	}
+	org.Values["AnchorPeers"] = &common.ConfigValue{ModPolicy: "Admins", Value: value}
+	return updated, nil
+}
+
+// configuredAnchorPeers reads an organization's anchor peers from the channel configuration
+func configuredAnchorPeers(config *common.Config, mspID string) ([]*peer.AnchorPeer, bool, error) {
+	org := config.GetChannelGroup().GetGroups()["Application"].GetGroups()[mspID]
+	if org == nil {
+		return nil, false, nil
+	}
+	value := org.GetValues()["AnchorPeers"]
+	if value == nil {
+		return nil, true, nil
+	}
+	var anchors peer.AnchorPeers
+	if err := proto.Unmarshal(value.GetValue(), &anchors); err != nil {
+		return nil, true, err
+	}
+	return anchors.GetAnchorPeers(), true, nil
+}
+
+var errConfigUnchanged = errors.New("the configuration is unchanged")
+
+// computeConfigUpdate computes the read and write sets taking original to updated, as configtxlator
+// compute_update does: changed elements get the next version, and unchanged siblings of a group
+// whose membership changed are carried at their current version
+func computeConfigUpdate(channel string, original, updated *common.Config) (*common.ConfigUpdate, error) {
+	if original.GetChannelGroup() == nil || updated.GetChannelGroup() == nil {
+		return nil, errors.New("the configuration has no channel group")
+	}
+	readSet, writeSet, changed := computeGroupUpdate(original.ChannelGroup, updated.ChannelGroup)
+	if !changed {
+		return nil, errConfigUnchanged
+	}
+	return &common.ConfigUpdate{ChannelId: channel, ReadSet: readSet, WriteSet: writeSet}, nil
+}
+
+func computeGroupUpdate(original, updated *common.ConfigGroup) (*common.ConfigGroup, *common.ConfigGroup, bool) {
+	readPolicies, writePolicies, samePolicies, policyMembers := computePoliciesUpdate(original.GetPolicies(), updated.GetPolicies())
+	readValues, writeValues, sameValues, valueMembers := computeValuesUpdate(original.GetValues(), updated.GetValues())
+	readGroups, writeGroups, sameGroups, groupMembers := computeGroupsUpdate(original.GetGroups(), updated.GetGroups())
+
+	if !policyMembers && !valueMembers && !groupMembers && original.GetModPolicy() == updated.GetModPolicy() {
+		if len(readPolicies)+len(writePolicies)+len(readValues)+len(writeValues)+len(readGroups)+len(writeGroups) == 0 {
+			return &common.ConfigGroup{Version: original.GetVersion()}, &common.ConfigGroup{Version: original.GetVersion()}, false
+		}
+		return &common.ConfigGroup{Version: original.GetVersion(), Policies: readPolicies, Values: readValues, Groups: readGroups},
+			&common.ConfigGroup{Version: original.GetVersion(), Policies: writePolicies, Values: writeValues, Groups: writeGroups}, true
+	}
+
+	for name, policy := range samePolicies {
+		readPolicies[name], writePolicies[name] = policy, policy
+	}
+	for name, value := range sameValues {
+		readValues[name], writeValues[name] = value, value
+	}
+	for name, group := range sameGroups {
+		readGroups[name], writeGroups[name] = group, group
+	}
+	return &common.ConfigGroup{Version: original.GetVersion(), Policies: readPolicies, Values: readValues, Groups: readGroups},
+		&common.ConfigGroup{Version: original.GetVersion() + 1, Policies: writePolicies, Values: writeValues, Groups: writeGroups, ModPolicy: updated.GetModPolicy()}, true
+}
+
+func computePoliciesUpdate(original, updated map[string]*common.ConfigPolicy) (readSet, writeSet, sameSet map[string]*common.ConfigPolicy, membersChanged bool) {
+	readSet, writeSet, sameSet = map[string]*common.ConfigPolicy{}, map[string]*common.ConfigPolicy{}, map[string]*common.ConfigPolicy{}
+	for name, o := range original {
+		u, ok := updated[name]
+		if !ok {
+			membersChanged = true
+			continue
+		}
+		if o.GetModPolicy() == u.GetModPolicy() && proto.Equal(o.GetPolicy(), u.GetPolicy()) {
+			sameSet[name] = &common.ConfigPolicy{Version: o.GetVersion()}
+			continue
+		}
+		writeSet[name] = &common.ConfigPolicy{Version: o.GetVersion() + 1, ModPolicy: u.GetModPolicy(), Policy: u.GetPolicy()}
+	}
+	for name, u := range updated {
+		if _, ok := original[name]; !ok {
+			membersChanged = true
+			writeSet[name] = &common.ConfigPolicy{ModPolicy: u.GetModPolicy(), Policy: u.GetPolicy()}
+		}
+	}
+	return
+}
+
+func computeValuesUpdate(original, updated map[string]*common.ConfigValue) (readSet, writeSet, sameSet map[string]*common.ConfigValue, membersChanged bool) {
+	readSet, writeSet, sameSet = map[string]*common.ConfigValue{}, map[string]*common.ConfigValue{}, map[string]*common.ConfigValue{}
+	for name, o := range original {
+		u, ok := updated[name]
+		if !ok {
+			membersChanged = true
+			continue
+		}
+		if o.GetModPolicy() == u.GetModPolicy() && bytes.Equal(o.GetValue(), u.GetValue()) {
+			sameSet[name] = &common.ConfigValue{Version: o.GetVersion()}
+			continue
+		}
+		writeSet[name] = &common.ConfigValue{Version: o.GetVersion() + 1, ModPolicy: u.GetModPolicy(), Value: u.GetValue()}
+	}
+	for name, u := range updated {
+		if _, ok := original[name]; !ok {
+			membersChanged = true
+			writeSet[name] = &common.ConfigValue{ModPolicy: u.GetModPolicy(), Value: u.GetValue()}
+		}
+	}
+	return
+}
+
+func computeGroupsUpdate(original, updated map[string]*common.ConfigGroup) (readSet, writeSet, sameSet map[string]*common.ConfigGroup, membersChanged bool) {
+	readSet, writeSet, sameSet = map[string]*common.ConfigGroup{}, map[string]*common.ConfigGroup{}, map[string]*common.ConfigGroup{}
+	for name, o := range original {
+		u, ok := updated[name]
+		if !ok {
+			membersChanged = true
+			continue
+		}
+		groupRead, groupWrite, changed := computeGroupUpdate(o, u)
+		if !changed {
+			sameSet[name] = groupRead
+			continue
+		}
+		readSet[name], writeSet[name] = groupRead, groupWrite
+	}
+	for name, u := range updated {
+		if _, ok := original[name]; ok {
+			continue
+		}
+		membersChanged = true
+		_, groupWrite, _ := computeGroupUpdate(&common.ConfigGroup{}, u)
+		writeSet[name] = &common.ConfigGroup{ModPolicy: u.GetModPolicy(), Policies: groupWrite.Policies, Values: groupWrite.Values, Groups: groupWrite.Groups}
+	}
+	return
+}
+
+// configUpdateEnvelope wraps a configuration update, signed by the admin, in a CONFIG_UPDATE
+// transaction for the orderer
+func configUpdateEnvelope(channel string, update *common.ConfigUpdate, admin *networkAdmin) (*common.Envelope, error) {
+	updateBytes, err := proto.Marshal(update)
+	if err != nil {
+		return nil, err
+	}
+	signatureHeader, _, err := admin.signatureHeader()
+	if err != nil {
+		return nil, err
+	}
+	signature, err := admin.signDigest(signatureHeader, updateBytes)
+	if err != nil {
+		return nil, err
+	}
+	data, err := proto.Marshal(&common.ConfigUpdateEnvelope{
+		ConfigUpdate: updateBytes,
+		Signatures:   []*common.ConfigSignature{{SignatureHeader: signatureHeader, Signature: signature}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	channelHeader, err := proto.Marshal(&common.ChannelHeader{
+		Type:      int32(common.HeaderType_CONFIG_UPDATE),
+		ChannelId: channel,
+		Timestamp: timestamppb.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	payloadSignatureHeader, _, err := admin.signatureHeader()
+	if err != nil {
+		return nil, err
+	}
+	payload, err := proto.Marshal(&common.Payload{
+		Header: &common.Header{ChannelHeader: channelHeader, SignatureHeader: payloadSignatureHeader},
+		Data:   data,
+	})
+	if err != nil {
+		return nil, err
+	}
+	envelopeSignature, err := admin.signDigest(payload)
+	if err != nil {
+		return nil, err
+	}
+	return &common.Envelope{Payload: payload, Signature: envelopeSignature}, nil
+}
+
+func broadcast(ctx context.Context, conn grpc.ClientConnInterface, envelope *common.Envelope) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	stream, err := orderer.NewAtomicBroadcastClient(conn).Broadcast(ctx)
+	if err != nil {
+		return err
+	}
+	if err := stream.Send(envelope); err != nil {
+		return err
+	}
+	resp, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	stream.CloseSend()
+	if resp.GetStatus() != common.Status_SUCCESS {
+		return fmt.Errorf("orderer returned %s: %s", resp.GetStatus(), resp.GetInfo())
+	}
+	return nil
+}
+
+// verify checks every orderer is an active consenter, every peer is on the channel and each
+// organization's configured anchor peers match the topology, reporting all mismatches at once
+func (b *networkBootstrap) verify(ctx context.Context) error {
+	t := b.topology
+	var problems []string
+	for _, o := range t.Orderers {
+		status, err := ordererChannelStatus(ctx, t, o)
+		switch {
+		case err != nil:
+			problems = append(problems, fmt.Sprintf("%s: %v", o.Name, err))
+		case status == nil:
+			problems = append(problems, fmt.Sprintf("%s has not joined %s", o.Name, t.Channel))
+		case status.Status != "active" || status.ConsensusRelation != "consenter":
+			problems = append(problems, fmt.Sprintf("%s is a %s in status %s", o.Name, status.ConsensusRelation, status.Status))
+		default:
+			log.Printf("✅ %s is an active consenter at height %d", o.Name, status.Height)
+		}
+	}
+
+	var config *common.Config
+	for _, org := range t.Orgs {
+		admin, err := loadNetworkAdmin(t, org)
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		for _, p := range org.Peers {
+			conn, err := dialPeer(t, org, p)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", p.Name, err))
+				continue
+			}
+			channels, err := peerChannels(ctx, conn, admin)
+			if err == nil && slices.Contains(channels, t.Channel) && config == nil {
+				config, err = channelConfig(ctx, conn, admin, t.Channel)
+			}
+			conn.Close()
+			switch {
+			case err != nil:
+				problems = append(problems, fmt.Sprintf("%s: %v", p.Name, err))
+			case !slices.Contains(channels, t.Channel):
+				problems = append(problems, fmt.Sprintf("%s has not joined %s", p.Name, t.Channel))
+			default:
+				log.Printf("✅ %s is on %s", p.Name, t.Channel)
+			}
+		}
+	}
+	if config != nil {
+		problems = append(problems, checkChannelOrgs(config, t.Orgs)...)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%d problem(s):\n  %s", len(problems), strings.Join(problems, "\n  "))
+	}
+	log.Printf("🎉 %s matches the topology", t.Channel)
+	return nil
+}
+
+// checkChannelOrgs compares the channel's application organizations and anchor peers with the
+// topology
+func checkChannelOrgs(config *common.Config, orgs []networkPeerOrg) []string {
+	var problems []string
+	for _, org := range orgs {
+		configured, member, err := configuredAnchorPeers(config, org.MSPID)
+		switch {
+		case err != nil:
+			problems = append(problems, fmt.Sprintf("%s anchor peers: %v", org.Name, err))
+			continue
+		case !member:
+			problems = append(problems, fmt.Sprintf("%s is not an organization of the channel", org.MSPID))
+			continue
+		}
+		want, got := anchorAddresses(org.anchors()), anchorAddresses(configured)
+		if !slices.Equal(want, got) {
+			problems = append(problems, fmt.Sprintf("%s anchor peers are %v, want %v", org.Name, got, want))
+		}
+	}
+	return problems
+}
+
+func anchorAddresses(anchors []*peer.AnchorPeer) []string {
+	addresses := []string{}
+	for _, anchor := range anchors {
+		addresses = append(addresses, net.JoinHostPort(anchor.GetHost(), strconv.Itoa(int(anchor.GetPort()))))
+	}
+	slices.Sort(addresses)
+	return addresses
+}
@@ -0,0 +1,210 @@
+package gateway
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestLoadNetworkTopology(t *testing.T) {
+	topology, err := loadNetworkTopology("../test-network/topology.json")
+	if err != nil {
+		t.Fatalf("expected the test network topology to load, got %v", err)
+	}
+	org := topology.Orgs[0]
+	if org.AdminMSP != "organizations/peerOrganizations/org1.example.com/users/Admin@org1.example.com/msp" ||
+		org.TLSCA != "organizations/peerOrganizations/org1.example.com/tlsca/tlsca.org1.example.com-cert.pem" {
+		t.Errorf("expected the cryptogen layout, got %s and %s", org.AdminMSP, org.TLSCA)
+	}
+	if got := topology.path(topology.Block); got != "../test-network/channel-artifacts/mychannel.block" {
+		t.Errorf("expected paths relative to the topology file, got %s", got)
+	}
+	if anchors := anchorAddresses(org.anchors()); len(anchors) != 1 || anchors[0] != "peer0.org1.example.com:7051" {
+		t.Errorf("unexpected anchors %v", anchors)
+	}
+
+	for name, edit := range map[string]func(*networkTopology){
+		"channel":   func(n *networkTopology) { n.Channel = "MyChannel" },
+		"duplicate": func(n *networkTopology) { n.Orgs[1].MSPID = n.Orgs[0].MSPID },
+		"anchor":    func(n *networkTopology) { n.Orgs[0].Peers[0].Anchor = "peer0:0" },
+		"orderer":   func(n *networkTopology) { n.Orderers[0].ClientKey = "" },
+	} {
+		broken, _ := loadNetworkTopology("../test-network/topology.json")
+		edit(broken)
+		if err := broken.validate(); err == nil {
+			t.Errorf("expected the %s change to be refused", name)
+		}
+	}
+}
+
+func TestConnectionProfile(t *testing.T) {
+	dir := t.TempDir()
+	certFile, _ := writeSelfSignedCert(t, dir, "tlsca.org1.example.com")
+	topology := &networkTopology{dir: dir}
+	org := networkPeerOrg{
+		Name: "Org1", MSPID: "Org1MSP", TLSCA: filepath.Base(certFile),
+		CA:    &networkCA{Name: "ca.org1.example.com", CAName: "ca-org1", URL: "https://localhost:7054", TLSCert: filepath.Base(certFile)},
+		Peers: []networkPeer{{Name: "peer0.org1.example.com", Address: "localhost:7051"}},
+	}
+	profile, err := connectionProfile(topology, org)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), `{"name":"test-network-org1","version":"1.0.0","client":`) {
+		t.Errorf("expected the template's key order, got %.60s", data)
+	}
+	var decoded struct {
+		Organizations map[string]struct {
+			CertificateAuthorities []string `json:"certificateAuthorities"`
+		} `json:"organizations"`
+		Peers map[string]struct {
+			URL        string `json:"url"`
+			TLSCACerts struct {
+				PEM string `json:"pem"`
+			} `json:"tlsCACerts"`
+		} `json:"peers"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	p := decoded.Peers["peer0.org1.example.com"]
+	if block, _ := pem.Decode([]byte(p.TLSCACerts.PEM)); p.URL != "grpcs://localhost:7051" || block == nil {
+		t.Errorf("expected the peer URL and an inlined PEM, got %+v", p)
+	}
+	if cas := decoded.Organizations["Org1"].CertificateAuthorities; len(cas) != 1 || cas[0] != "ca.org1.example.com" {
+		t.Errorf("expected the organization to name its CA, got %v", cas)
+	}
+
+	yaml := string(profile.yaml())
+	for _, want := range []string{"---\nname: test-network-org1\n", "        endorser: \"300\"\n", "      pem: |\n        -----BEGIN CERTIFICATE-----\n", "      pem:\n      - |\n        -----BEGIN", "    verify: false\n", "  ssl-target-name-override: peer0.org1.example.com\n"} {
+		if !strings.Contains(yaml, want) {
+			t.Errorf("expected %q in the YAML profile:\n%s", want, yaml)
+		}
+	}
+}
+
+func TestComputeAnchorPeerUpdate(t *testing.T) {
+	msp := &common.ConfigValue{Version: 0, ModPolicy: "Admins", Value: []byte("msp")}
+	config := &common.Config{ChannelGroup: &common.ConfigGroup{
+		Version: 3, ModPolicy: "Admins",
+		Values: map[string]*common.ConfigValue{"Capabilities": {Version: 1, ModPolicy: "Admins", Value: []byte("v2")}},
+		Groups: map[string]*common.ConfigGroup{
+			"Application": {Version: 1, ModPolicy: "Admins", Groups: map[string]*common.ConfigGroup{
+				"Org1MSP": {Version: 2, ModPolicy: "Admins", Values: map[string]*common.ConfigValue{"MSP": msp},
+					Policies: map[string]*common.ConfigPolicy{"Admins": {Version: 0, ModPolicy: "Admins"}}},
+				"Org2MSP": {Version: 0, ModPolicy: "Admins"},
+			}},
+		},
+	}}
+	anchors := []*peer.AnchorPeer{{Host: "peer0.org1.example.com", Port: 7051}}
+	updated, err := withAnchorPeers(config, "Org1MSP", anchors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.ChannelGroup.Groups["Application"].Groups["Org1MSP"].Values["AnchorPeers"] != nil {
+		t.Fatal("expected the current configuration left untouched")
+	}
+	update, err := computeConfigUpdate("mychannel", config, updated)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	readOrg := update.ReadSet.Groups["Application"].Groups["Org1MSP"]
+	writeOrg := update.WriteSet.Groups["Application"].Groups["Org1MSP"]
+	if update.ChannelId != "mychannel" || update.WriteSet.Version != 3 || update.WriteSet.Groups["Application"].Version != 1 {
+		t.Errorf("expected the channel and application groups at their current versions, got %+v", update.WriteSet)
+	}
+	if readOrg.Version != 2 || writeOrg.Version != 3 || writeOrg.ModPolicy != "Admins" {
+		t.Errorf("expected the organization group to move from version 2 to 3, got %d to %d", readOrg.Version, writeOrg.Version)
+	}
+	if value := writeOrg.Values["AnchorPeers"]; value == nil || value.Version != 0 || value.ModPolicy != "Admins" {
+		t.Errorf("expected the new anchor peers value, got %+v", value)
+	}
+	if kept := writeOrg.Values["MSP"]; kept == nil || kept.Value != nil || writeOrg.Policies["Admins"] == nil {
+		t.Errorf("expected the unchanged MSP and policy carried by version only, got %+v", writeOrg)
+	}
+	if _, ok := update.WriteSet.Groups["Application"].Groups["Org2MSP"]; ok {
+		t.Error("expected the untouched organization left out of the update")
+	}
+
+	if _, err := computeConfigUpdate("mychannel", updated, proto.Clone(updated).(*common.Config)); !errors.Is(err, errConfigUnchanged) {
+		t.Errorf("expected an unchanged configuration, got %v", err)
+	}
+	orgs := []networkPeerOrg{
+		{Name: "Org1", MSPID: "Org1MSP", Peers: []networkPeer{{Anchor: "peer0.org1.example.com:7051"}}},
+		{Name: "Org2", MSPID: "Org2MSP", Peers: []networkPeer{{Anchor: "peer0.org2.example.com:9051"}}},
+		{Name: "Org3", MSPID: "Org3MSP"},
+	}
+	problems := checkChannelOrgs(updated, orgs)
+	if len(problems) != 2 || !strings.Contains(problems[0], "Org2 anchor peers are []") || !strings.Contains(problems[1], "Org3MSP is not") {
+		t.Errorf("expected Org2's anchors and Org3's membership reported, got %v", problems)
+	}
+}
+
+func TestOrdererParticipation(t *testing.T) {
+	var joined []byte
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch {
+		case r.Method == http.MethodGet && joined == nil:
+			w.WriteHeader(http.StatusNotFound)
+		case r.Method == http.MethodGet:
+			w.Write([]byte(`{"name":"mychannel","consensusRelation":"consenter","status":"active","height":1}`))
+		case joined != nil:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			w.Write([]byte(`{"error":"cannot join: channel already exists"}`))
+		default:
+			file, _, err := r.FormFile("config-block")
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			joined, _ = io.ReadAll(file)
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "tlsca.pem")
+	os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0o600)
+	certFile, keyFile := writeSelfSignedCert(t, dir, "Admin")
+	topology := &networkTopology{Channel: "mychannel", dir: dir}
+	o := networkOrderer{Name: "example.com", Admin: strings.TrimPrefix(server.URL, "https://"), TLSCA: "tlsca.pem", ClientCert: certFile, ClientKey: keyFile}
+	ctx := context.Background()
+
+	if status, err := ordererChannelStatus(ctx, topology, o); err != nil || status != nil {
+		t.Fatalf("expected the channel to be missing, got %+v, %v", status, err)
+	}
+	if err := joinOrderer(ctx, topology, o, []byte("genesis")); err != nil || string(joined) != "genesis" {
+		t.Fatalf("expected the block posted, got %q, %v", joined, err)
+	}
+	if status, err := ordererChannelStatus(ctx, topology, o); err != nil || status.Status != "active" || status.ConsensusRelation != "consenter" {
+		t.Errorf("expected an active consenter, got %+v, %v", status, err)
+	}
+	if err := joinOrderer(ctx, topology, o, []byte("genesis")); err == nil || !strings.Contains(err.Error(), "405: cannot join") {
+		t.Errorf("expected the API's error, got %v", err)
+	}
+}
@@ -0,0 +1,222 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Notification delivery states
+const (
+	notifyDelivered = "delivered"
+	notifyFailed    = "failed"
+	notifyPending   = "pending"
+)
+
+// SOSNotification is the body posted to police unit webhooks and the notification gateway
+type SOSNotification struct {
+	AlertID    string  `json:"alert_id"`
+	DigitalID  string  `json:"digital_id"`
+	Latitude   float64 `json:"latitude"`
+	Longitude  float64 `json:"longitude"`
+	Accuracy   float64 `json:"accuracy,omitempty"`
+	Message    string  `json:"message,omitempty"`
+	Source     string  `json:"source,omitempty"`
+	RaisedAt   string  `json:"raised_at"`
+	MapURL     string  `json:"map_url"`
+	PoliceUnit string  `json:"police_unit,omitempty"`
+	TxID       string  `json:"tx_id"`
+	// AssignmentID is the dispatch assignment the receiving unit acknowledges
+	AssignmentID string `json:"assignment_id,omitempty"`
+
+	// Set for deliveries through the notification gateway, which sends the SMS or email
+	Channel       string `json:"channel,omitempty"`
+	To            string `json:"to,omitempty"`
+	RecipientName string `json:"recipient_name,omitempty"`
+	RecipientKind string `json:"recipient_kind,omitempty"`
+}
+
+// NotificationStatus reports one delivery
+type NotificationStatus struct {
+	Recipient string `json:"recipient"`
+	Kind      string `json:"kind"`
+	Channel   string `json:"channel"`
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+	// MessageID identifies SMS sent through SMS_PROVIDER, for delivery status lookups
+	MessageID string `json:"message_id,omitempty"`
+}
+
+// DispatchState summarises the deliveries for an alert: delivered, partial, pending, failed, or
+// none when there was nobody to notify
+type DispatchState struct {
+	State         string               `json:"state"`
+	Notifications []NotificationStatus `json:"notifications"`
+}
+
+// notifier posts notifications as JSON, signed with an HMAC-SHA256 of the body when a secret is set.
+// Units with a webhook are called directly; SMS goes through SMS_PROVIDER when one is configured,
+// and everything else through the gateway at gatewayURL.
+type notifier struct {
+	gatewayURL string
+	secret     []byte
+	client     *http.Client
+	timeout    time.Duration
+	wait       time.Duration
+}
+
+type delivery struct {
+	send   func(ctx context.Context) (messageID string, err error)
+	status NotificationStatus
+}
+
+// fanOut sends every notification concurrently and reports their state once all have finished or
+// the dispatch wait is over, whichever comes first
+func (n *notifier) fanOut(ctx context.Context, alert SOSNotification, unit *PoliceUnit, contacts []EmergencyContact) DispatchState {
+	deliveries := n.plan(alert, unit, contacts)
+	state := DispatchState{State: "none", Notifications: []NotificationStatus{}}
+	if len(deliveries) == 0 {
+		return state
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := range deliveries {
+		wg.Add(1)
+		go func(d *delivery) {
+			defer wg.Done()
+			// Deliveries outlive the request when they run past the dispatch wait
+			sendCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), n.timeout)
+			defer cancel()
+			messageID, err := d.send(sendCtx)
+			if err != nil {
+				log.Printf("SOS %s: failed to notify %s %s: %v", alert.AlertID, d.status.Kind, d.status.Recipient, err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			d.status.Status, d.status.MessageID = notifyDelivered, messageID
+			if err != nil {
+				d.status.Status, d.status.Error = notifyFailed, err.Error()
+			}
+		}(&deliveries[i])
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(n.wait):
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	counts := map[string]int{}
+	for _, d := range deliveries {
+		state.Notifications = append(state.Notifications, d.status)
+		counts[d.status.Status]++
+	}
+	switch {
+	case counts[notifyDelivered] == len(deliveries):
+		state.State = notifyDelivered
+	case counts[notifyFailed] == len(deliveries):
+		state.State = notifyFailed
+	case counts[notifyPending] == len(deliveries):
+		state.State = notifyPending
+	default:
+		state.State = "partial"
+	}
+	return state
+}
+
+// plan lists the deliveries for an alert: the police unit first, then each contact by SMS and email
+func (n *notifier) plan(alert SOSNotification, unit *PoliceUnit, contacts []EmergencyContact) []delivery {
+	var deliveries []delivery
+	add := func(kind, name, channel, to string) {
+		payload, url := alert, n.gatewayURL
+		send := func(ctx context.Context) (string, error) { return "", n.post(ctx, url, payload) }
+		switch {
+		case channel == "webhook":
+			url = to
+		case channel == "sms" && smsGateway != nil:
+			data := smsData{RecipientName: name, DigitalID: alert.DigitalID, MapURL: alert.MapURL, PoliceUnit: alert.PoliceUnit}
+			if unit != nil {
+				data.PolicePhone = unit.Phone
+			}
+			send = func(ctx context.Context) (string, error) {
+				record, err := smsGateway.send(ctx, "sos", to, alert.AlertID, data)
+				if err != nil {
+					return "", err
+				}
+				return record.MessageID, nil
+			}
+		default:
+			payload.Channel, payload.To, payload.RecipientName, payload.RecipientKind = channel, to, name, kind
+		}
+		deliveries = append(deliveries, delivery{
+			send:   send,
+			status: NotificationStatus{Recipient: name, Kind: kind, Channel: channel, Status: notifyPending},
+		})
+	}
+
+	if unit != nil {
+		if unit.WebhookURL != "" {
+			add(unit.kind()+"_unit", unit.Name, "webhook", unit.WebhookURL)
+		} else if unit.Phone != "" {
+			add(unit.kind()+"_unit", unit.Name, "sms", unit.Phone)
+		}
+	}
+	for _, contact := range contacts {
+		if contact.Phone != "" {
+			add("emergency_contact", contact.Name, "sms", contact.Phone)
+		}
+		if contact.Email != "" {
+			add("emergency_contact", contact.Name, "email", contact.Email)
+		}
+	}
+	return deliveries
+}
+
+func (n *notifier) post(ctx context.Context, url string, payload any) error {
+	if url == "" {
+		return errors.New("NOTIFY_WEBHOOK_URL is not set")
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(n.secret) > 0 {
+		req.Header.Set(signatureHeader, webhookSignature(n.secret, "", body))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.New("webhook responded with " + resp.Status)
+	}
+	return nil
+}
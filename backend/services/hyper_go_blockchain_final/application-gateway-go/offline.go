@@ -0,0 +1,216 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// errExternalSigner is returned when the gateway is asked to sign for an identity whose key is
+// only available to an external signer
+var errExternalSigner = errors.New("identity is signed externally, use the /api/v1/offline endpoints")
+
+// OfflineProposalRequest names the chaincode function to prepare for an external signer
+type OfflineProposalRequest struct {
+	Function string   `json:"function" binding:"required"`
+	Args     []string `json:"args"`
+}
+
+// OfflineSignedRequest returns bytes from a previous offline step with the external signer's
+// ECDSA signature (ASN.1 DER, low-S) over their digest
+type OfflineSignedRequest struct {
+	Bytes     []byte `json:"bytes" binding:"required"`
+	Signature []byte `json:"signature" binding:"required"`
+}
+
+// OfflineStep is a serialized proposal, transaction or commit status request waiting to be signed.
+// The signer signs Digest; Bytes are sent back unchanged with the signature.
+type OfflineStep struct {
+	TxID   string `json:"tx_id"`
+	Stage  string `json:"stage"`
+	Bytes  []byte `json:"bytes"`
+	Digest []byte `json:"digest"`
+	// Result is the chaincode response from endorsement, returned once the proposal is endorsed
+	Result []byte `json:"result,omitempty"`
+}
+
+func newOfflineStep(txID, stage string, serialize func() ([]byte, error), digest []byte) (OfflineStep, error) {
+	bytes, err := serialize()
+	if err != nil {
+		return OfflineStep{}, err
+	}
+	return OfflineStep{TxID: txID, Stage: stage, Bytes: bytes, Digest: digest}, nil
+}
+
+// PrepareProposal builds an unsigned proposal for the caller's identity on the request's target.
+// The caller's API key should map to an External-X.509 wallet identity, whose key never reaches
+// the gateway.
+func (ledgerService) PrepareProposal(ctx context.Context, req OfflineProposalRequest) (OfflineStep, error) {
+	proposal, err := targetFromContext(ctx).contractFor(ctx).NewProposal(req.Function, client.WithArguments(req.Args...))
+	if err != nil {
+		return OfflineStep{}, err
+	}
+	return newOfflineStep(proposal.TransactionID(), "proposal", proposal.Bytes, proposal.Digest())
+}
+
+// EndorseSignedProposal collects endorsements for an externally signed proposal and returns the
+// transaction to sign next
+func (ledgerService) EndorseSignedProposal(ctx context.Context, req OfflineSignedRequest) (OfflineStep, error) {
+	proposal, err := gatewayFromContext(ctx).NewSignedProposal(req.Bytes, req.Signature)
+	if err != nil {
+		return OfflineStep{}, malformedSigned("proposal", err)
+	}
+	if err := fabricBreaker.allow(time.Now()); err != nil {
+		return OfflineStep{}, err
+	}
+
+	ctx, span := startSpan(ctx, "fabric.offline_endorse", trace.SpanKindClient)
+	defer span.End()
+	span.SetAttributes(attribute.String("fabric.tx_id", proposal.TransactionID()))
+
+	transaction, err := endorse(ctx, proposal)
+	fabricBreaker.record(err, time.Now())
+	recordSpanError(span, err)
+	if err != nil {
+		return OfflineStep{}, err
+	}
+
+	step, err := newOfflineStep(transaction.TransactionID(), "transaction", transaction.Bytes, transaction.Digest())
+	step.Result = transaction.Result()
+	return step, err
+}
+
+// SubmitSignedTransaction sends an externally signed transaction to the orderer and returns the
+// commit status request to sign next
+func (ledgerService) SubmitSignedTransaction(ctx context.Context, req OfflineSignedRequest) (OfflineStep, error) {
+	transaction, err := gatewayFromContext(ctx).NewSignedTransaction(req.Bytes, req.Signature)
+	if err != nil {
+		return OfflineStep{}, malformedSigned("transaction", err)
+	}
+	if err := fabricBreaker.allow(time.Now()); err != nil {
+		return OfflineStep{}, err
+	}
+
+	ctx, span := startSpan(ctx, "fabric.offline_submit", trace.SpanKindClient)
+	defer span.End()
+	span.SetAttributes(attribute.String("fabric.tx_id", transaction.TransactionID()))
+
+	commit, err := submit(ctx, transaction)
+	fabricBreaker.record(err, time.Now())
+	recordSpanError(span, err)
+	if err != nil {
+		return OfflineStep{}, err
+	}
+	return newOfflineStep(commit.TransactionID(), "commit", commit.Bytes, commit.Digest())
+}
+
+// SignedCommitStatus waits for the commit status of an offline-signed transaction. As with
+// TransactionStatus, one that has not committed within COMMIT_STATUS_WAIT is reported as pending.
+func (ledgerService) SignedCommitStatus(ctx context.Context, req OfflineSignedRequest) (TransactionStatus, error) {
+	commit, err := gatewayFromContext(ctx).NewSignedCommit(req.Bytes, req.Signature)
+	if err != nil {
+		return TransactionStatus{}, malformedSigned("commit", err)
+	}
+	if err := fabricBreaker.allow(time.Now()); err != nil {
+		return TransactionStatus{}, err
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, getEnvDuration("COMMIT_STATUS_WAIT", 2*time.Second))
+	defer cancel()
+
+	result := TransactionStatus{TxID: commit.TransactionID()}
+	status, err := commit.StatusWithContext(waitCtx)
+	result.CheckedAt = time.Now().UTC().Format(time.RFC3339)
+	if err != nil {
+		if ctx.Err() == nil && waitCtx.Err() != nil {
+			fabricBreaker.record(nil, time.Now())
+			return result, nil
+		}
+		fabricBreaker.record(err, time.Now())
+		return TransactionStatus{}, err
+	}
+	fabricBreaker.record(nil, time.Now())
+
+	result.Committed = true
+	result.Valid = status.Successful
+	result.ValidationCode = status.Code.String()
+	result.BlockNumber = status.BlockNumber
+	return result, nil
+}
+
+// malformedSigned reports bytes that do not decode as the expected message as a field error
+func malformedSigned(kind string, err error) error {
+	return ValidationErrors{{Field: "bytes", Message: "not a serialized " + kind + ": " + err.Error()}}
+}
+
+func prepareOfflineProposal(c *gin.Context) {
+	var req OfflineProposalRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+
+	step, err := ledger.PrepareProposal(c.Request.Context(), req)
+	if err != nil {
+		respondServiceError(c, "Failed to prepare proposal", err)
+		return
+	}
+
+	respondData(c, http.StatusOK, step)
+}
+
+func endorseOfflineProposal(c *gin.Context) {
+	var req OfflineSignedRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+
+	step, err := ledger.EndorseSignedProposal(c.Request.Context(), req)
+	if err != nil {
+		respondServiceError(c, "Failed to endorse proposal", err)
+		return
+	}
+
+	respondData(c, http.StatusOK, step)
+}
+
+func submitOfflineTransaction(c *gin.Context) {
+	var req OfflineSignedRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+
+	step, err := ledger.SubmitSignedTransaction(c.Request.Context(), req)
+	if err != nil {
+		respondServiceError(c, "Failed to submit transaction", err)
+		return
+	}
+
+	respondData(c, http.StatusAccepted, step)
+}
+
+func offlineCommitStatus(c *gin.Context) {
+	var req OfflineSignedRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+
+	status, err := ledger.SignedCommitStatus(c.Request.Context(), req)
+	if err != nil {
+		respondServiceError(c, "Failed to get transaction status", err)
+		return
+	}
+
+	respondData(c, http.StatusOK, status)
+}
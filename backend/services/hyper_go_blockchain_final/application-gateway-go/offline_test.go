@@ -0,0 +1,74 @@
+package gateway
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"github.com/hyperledger/fabric-gateway/pkg/hash"
+	"github.com/hyperledger/fabric-gateway/pkg/identity"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func TestOfflineProposalRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	writeWalletIdentity(t, dir, "issuer")
+	files, err := readWalletDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := files["issuer"]
+	privateKey, err := identity.PrivateKeyFromPEM([]byte(file.Credentials.PrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Type, file.Credentials.PrivateKey = "External-X.509", ""
+
+	id, sign, err := file.credentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sign(make([]byte, 32)); !errors.Is(err, errExternalSigner) {
+		t.Errorf("expected external identities to refuse to sign, got %v", err)
+	}
+
+	conn, err := grpc.NewClient("passthrough:///unused", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	gw, err := client.Connect(id, client.WithSign(sign), client.WithHash(hash.SHA256), client.WithClientConnection(conn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gw.Close()
+
+	ctx := withSigner(context.Background(), &walletIdentity{Label: "issuer", MSPID: file.MSPID, gateway: gw})
+	ctx = withTarget(ctx, &fabricTarget{Name: "default", Channel: "mychannel", Chaincode: "basic"})
+	step, err := ledger.PrepareProposal(ctx, OfflineProposalRequest{Function: "CreateDID", Args: []string{"did:sih:1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if step.Stage != "proposal" || step.TxID == "" || len(step.Bytes) == 0 || len(step.Digest) != 32 {
+		t.Fatalf("unexpected proposal step %+v", step)
+	}
+
+	signature, err := ecdsa.SignASN1(rand.Reader, privateKey.(*ecdsa.PrivateKey), step.Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proposal, err := gw.NewSignedProposal(step.Bytes, signature)
+	if err != nil || proposal.TransactionID() != step.TxID {
+		t.Errorf("signed proposal did not round-trip: %v", err)
+	}
+
+	var validationErrs ValidationErrors
+	_, err = ledger.EndorseSignedProposal(ctx, OfflineSignedRequest{Bytes: []byte("not a proposal"), Signature: signature})
+	if !errors.As(err, &validationErrs) {
+		t.Errorf("expected malformed proposal bytes to be a validation error, got %v", err)
+	}
+}
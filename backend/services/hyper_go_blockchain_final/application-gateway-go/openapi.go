@@ -0,0 +1,544 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+
+	"assetTransfer/qrverify"
+
+	"github.com/gin-gonic/gin"
+)
+
+const swaggerUIVersion = "5.17.14"
+
+// apiOperation documents one /api/v1 route. Request and response schemas are generated from the
+// Go types, so the spec follows the structs the handlers bind and return.
+type apiOperation struct {
+	method    string
+	path      string
+	summary   string
+	tag       string
+	query     interface{}
+	request   interface{}
+	response  interface{}
+	status    int
+	committed bool
+	multipart bool
+	binary    string
+}
+
+// mutationResult is the data returned by create, update and delete routes
+type mutationResult map[string]string
+
+var apiOperations = []apiOperation{
+	{method: http.MethodPost, path: "/did/", summary: "Create a DID", tag: "DID", request: CreateDIDRequest{}, response: mutationResult{}, status: http.StatusCreated, committed: true},
+	{method: http.MethodPost, path: "/did/bulk", summary: "Issue DIDs for a tour group from a JSON, CSV or multipart manifest and report each row's outcome (format=csv|xlsx to download the report)", tag: "DID", query: BulkDIDRequest{}, request: []CreateDIDRequest{}, response: BulkDIDReport{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/did/:id", summary: "Get a DID", tag: "DID", response: DIDDocument{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/did/:id/verify", summary: "Verify that a DID exists, is unexpired and has not been revoked", tag: "DID", response: DIDVerification{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/did/:id/qr", summary: "Render a signed QR code for a valid DID (format=png|svg)", tag: "DID", status: http.StatusOK, binary: "image/png"},
+	{method: http.MethodPost, path: "/did/verify-qr", summary: "Verify a scanned DID QR payload", tag: "DID", request: VerifyQRRequest{}, response: QRVerification{}, status: http.StatusOK},
+	{method: http.MethodPost, path: "/did/verify-qr/offline", summary: "Verify a scanned DID QR payload against a signed revocation list, without the ledger", tag: "DID", request: VerifyQROfflineRequest{}, response: qrverify.Verdict{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/did/bundle", summary: "Download the signed bundle of unexpired DIDs for offline checks, or with since a signed delta from the version the device holds", tag: "DID", query: OfflineListRequest{}, status: http.StatusOK, binary: "application/jwt"},
+	{method: http.MethodPut, path: "/did/:id", summary: "Update a DID", tag: "DID", request: UpdateDIDRequest{}, response: mutationResult{}, status: http.StatusOK, committed: true},
+	{method: http.MethodDelete, path: "/did/:id", summary: "Delete a DID", tag: "DID", request: DeleteRequest{}, response: mutationResult{}, status: http.StatusOK, committed: true},
+
+	{method: http.MethodPost, path: "/incident/", summary: "Create an incident", tag: "Incident", request: CreateIncidentRequest{}, response: mutationResult{}, status: http.StatusCreated, committed: true},
+	{method: http.MethodGet, path: "/incident", summary: "Search incidents by time range, status, severity and reporter", tag: "Incident", query: IncidentSearchRequest{}, response: IncidentPage{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/incident/export", summary: "Export incidents over a period as CSV or XLSX (format=csv|xlsx)", tag: "Incident", query: IncidentExportRequest{}, status: http.StatusOK, binary: "text/csv"},
+	{method: http.MethodGet, path: "/incident/:id", summary: "Get an incident", tag: "Incident", response: IncidentDocument{}, status: http.StatusOK},
+	{method: http.MethodPut, path: "/incident/:id", summary: "Update an incident", tag: "Incident", request: UpdateIncidentRequest{}, response: mutationResult{}, status: http.StatusOK, committed: true},
+	{method: http.MethodPut, path: "/incident/:id/status", summary: "Move an incident to acknowledged, resolved or closed", tag: "Incident", request: UpdateIncidentStatusRequest{}, response: mutationResult{}, status: http.StatusOK, committed: true},
+	{method: http.MethodPost, path: "/incident/:id/merge", summary: "Close duplicate reports of an incident, pointing each at it", tag: "Incident", request: MergeIncidentsRequest{}, response: mutationResult{}, status: http.StatusOK, committed: true},
+	{method: http.MethodGet, path: "/incident/:id/changes", summary: "Long-poll for status changes, new evidence and e-FIRs on an incident (up to 30s)", tag: "Incident", query: IncidentChangesRequest{}, response: IncidentChanges{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/incident/:id/calls", summary: "List the helpline calls anchored against an incident, earliest first", tag: "Incident", response: []CallDocument{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/incident/:id/cctv", summary: "List the CCTV export manifests anchored against an incident, by window start", tag: "Incident", response: []CCTVManifestDocument{}, status: http.StatusOK},
+	{method: http.MethodDelete, path: "/incident/:id", summary: "Delete an incident", tag: "Incident", request: DeleteRequest{}, response: mutationResult{}, status: http.StatusOK, committed: true},
+
+	{method: http.MethodPost, path: "/incident/:id/efir", summary: "Generate an e-FIR PDF, numbered from the station's register, and anchor its hash (Accept: application/pdf returns the file)", tag: "Incident", request: GenerateEFIRRequest{}, response: mutationResult{}, status: http.StatusCreated, committed: true},
+	{method: http.MethodGet, path: "/incident/:id/timeline", summary: "Reconstruct an incident's timeline from its ledger history, audit entries, evidence, location pings, dispatch and notifications", tag: "Incident", query: IncidentTimelineRequest{}, response: IncidentTimeline{}, status: http.StatusOK},
+	{method: http.MethodPost, path: "/incident/:id/timeline/annexure", summary: "Render the incident's timeline as a court annexure PDF and anchor its hash (Accept: application/pdf returns the file)", tag: "Incident", request: TimelineAnnexureRequest{}, response: mutationResult{}, status: http.StatusCreated, committed: true},
+	{method: http.MethodGet, path: "/incident/:id/timeline/annexures", summary: "List the timeline annexures generated for an incident", tag: "Incident", response: []TimelineAnnexure{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/incident/:id/archives", summary: "List the cold-storage archives recorded for an incident", tag: "Incident", response: []IncidentArchive{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/incident/:id/archives/:archiveId", summary: "Download an incident's archive bundle with its ledger proofs, checked against its anchored digest", tag: "Incident", status: http.StatusOK, binary: "application/json"},
+	{method: http.MethodGet, path: "/efir/register", summary: "List a police station's FIR numbers for a year, with the numbers never filed", tag: "Incident", query: FIRRegisterRequest{}, response: FIRRegister{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/efir/:firId", summary: "Get the ledger record of a generated e-FIR", tag: "Incident", response: EFIRDocument{}, status: http.StatusOK},
+
+	{method: http.MethodPost, path: "/sos", summary: "Record an SOS, route it to the nearest police unit and notify the tourist's emergency contacts; an unconfirmed SOS from the app is held for its confirmation window instead and answered with 202", tag: "SOS", request: SOSRequest{}, response: SOSResult{}, status: http.StatusCreated, committed: true},
+	{method: http.MethodPost, path: "/sos/:id/confirm", summary: "Raise a held SOS before its confirmation window ends", tag: "SOS", request: SOSDecisionRequest{}, response: SOSResult{}, status: http.StatusCreated, committed: true},
+	{method: http.MethodPost, path: "/sos/:id/cancel", summary: "Cancel a held SOS triggered by accident, recording the cancellation on the ledger; with passkeys enabled, needs an assertion for sos.cancel", tag: "SOS", request: SOSDecisionRequest{}, response: SOSTrigger{}, status: http.StatusOK, committed: true},
+	{method: http.MethodGet, path: "/sos/:id/trigger", summary: "Get a held SOS, or how its confirmation window ended", tag: "SOS", response: SOSTrigger{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/sms", summary: "List the SMS sent for an SOS alert or incident, with delivery status", tag: "SOS", query: SMSStatusRequest{}, response: []SMSRecord{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/geofence/zones", summary: "List geofence zones", tag: "Geofence", response: []Zone{}, status: http.StatusOK},
+	{method: http.MethodPost, path: "/geofence/zones", summary: "Register a geofence zone", tag: "Geofence", request: CreateZoneRequest{}, response: mutationResult{}, status: http.StatusCreated, committed: true},
+	{method: http.MethodPost, path: "/geofence/zones/import", summary: "Import zones from a GeoJSON or KML file, validating, simplifying and checking them for overlaps, and report each feature's outcome", tag: "Geofence", query: ZoneImportRequest{}, request: struct{}{}, response: ZoneImportReport{}, status: http.StatusOK, multipart: true},
+	{method: http.MethodGet, path: "/geofence/zones/export", summary: "Export every zone as GeoJSON or KML (format=geojson|kml)", tag: "Geofence", query: ZoneExportRequest{}, status: http.StatusOK, binary: geoJSONMediaType},
+	{method: http.MethodGet, path: "/geofence/zones/:id", summary: "Get a geofence zone", tag: "Geofence", response: Zone{}, status: http.StatusOK},
+	{method: http.MethodPut, path: "/geofence/zones/:id", summary: "Replace a geofence zone's geometry, risk level and hours", tag: "Geofence", request: UpdateZoneRequest{}, response: mutationResult{}, status: http.StatusOK, committed: true},
+	{method: http.MethodDelete, path: "/geofence/zones/:id", summary: "Delete a geofence zone", tag: "Geofence", request: DeleteRequest{}, response: mutationResult{}, status: http.StatusOK, committed: true},
+	{method: http.MethodPost, path: "/geofence/evaluate", summary: "List the zones containing a coordinate and the highest active risk level", tag: "Geofence", request: EvaluateGeofenceRequest{}, response: GeofenceEvaluation{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/geofence/risk", summary: "List zones with their incident history risk scores, riskiest first, optionally only those containing a position", tag: "Geofence", query: ZoneRiskRequest{}, response: []ZoneRisk{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/geofence/risk/:id", summary: "Get a zone's risk score and the risk level in force now", tag: "Geofence", response: ZoneRisk{}, status: http.StatusOK},
+	{method: http.MethodPost, path: "/geofence/risk/recompute", summary: "Rescore every zone from its incident history now, recording changed scores in the zone registry", tag: "Geofence", response: ZoneRiskRun{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/geofence/routes", summary: "Route options between two positions from the routing engine, ranked by zone risk and nearby incidents at the time of travel with high-risk stretches flagged (503 ROUTING_DISABLED unless configured)", tag: "Geofence", query: SafeRouteRequest{}, response: SafeRoutes{}, status: http.StatusOK},
+	{method: http.MethodPost, path: "/geofence/crowd/estimates", summary: "Ingest crowd estimates from a telecom or camera analytics provider, raising an overcrowding advisory for each zone that saturates", tag: "Geofence", request: CrowdIngestRequest{}, response: CrowdIngestion{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/geofence/crowd", summary: "List zones with fresh crowd estimates, most crowded first", tag: "Geofence", query: CrowdListRequest{}, response: []CrowdZone{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/geofence/crowd/:id", summary: "Get a zone's crowd level from its fresh estimates", tag: "Geofence", response: CrowdZone{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/geofence/rules", summary: "List geofence alert rules, optionally for one zone (zoneId)", tag: "Geofence", response: []AlertRule{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/geofence/rules/:id", summary: "Get a geofence alert rule", tag: "Geofence", response: AlertRule{}, status: http.StatusOK},
+	{method: http.MethodPut, path: "/geofence/rules/:id", summary: "Create or replace a zone's entry, exit, dwell, after-dark entry or group separation alert rule", tag: "Geofence", request: AlertRuleRequest{}, response: AlertRule{}, status: http.StatusOK},
+	{method: http.MethodDelete, path: "/geofence/rules/:id", summary: "Delete a geofence alert rule", tag: "Geofence", response: mutationResult{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/geofence/groups", summary: "List tourist groups watched by group separation rules", tag: "Geofence", response: []TouristGroup{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/geofence/groups/:id", summary: "Get a tourist group", tag: "Geofence", response: TouristGroup{}, status: http.StatusOK},
+	{method: http.MethodPut, path: "/geofence/groups/:id", summary: "Create or replace a tourist group", tag: "Geofence", request: TouristGroupRequest{}, response: TouristGroup{}, status: http.StatusOK},
+	{method: http.MethodDelete, path: "/geofence/groups/:id", summary: "Delete a tourist group", tag: "Geofence", response: mutationResult{}, status: http.StatusOK},
+	{method: http.MethodPost, path: "/location/pings", summary: "Record a batch of location pings from the tourist app and evaluate geofences at the latest position", tag: "Location", request: LocationPingsRequest{}, response: LocationPingsResult{}, status: http.StatusAccepted},
+	{method: http.MethodPost, path: "/location/estimate", summary: "Fuse a GPS fix with Wi-Fi and cell observations into a position with an accuracy radius, without recording it", tag: "Location", request: LocationEstimateRequest{}, response: LocationEstimate{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/location/:digitalId", summary: "Get a tourist's live location", tag: "Location", response: LiveLocation{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/location/:digitalId/anchors", summary: "List the location digests anchored on the ledger for a tourist", tag: "Location", response: []LocationAnchor{}, status: http.StatusOK},
+	{method: http.MethodPost, path: "/stays/check-in", summary: "Record a tourist's check-in at a registered hotel or homestay, making the property their last known location", tag: "Stays", request: StayCheckInRequest{}, response: StayReport{}, status: http.StatusCreated, committed: true},
+	{method: http.MethodPost, path: "/stays/check-out", summary: "Record a tourist's check-out from a hotel or homestay", tag: "Stays", request: StayCheckOutRequest{}, response: StayReport{}, status: http.StatusOK, committed: true},
+	{method: http.MethodGet, path: "/stays/:digitalId", summary: "List a tourist's stays, latest check-in first", tag: "Stays", response: []StayDocument{}, status: http.StatusOK},
+	{method: http.MethodPost, path: "/permits/applications", summary: "Apply for a permit to enter a restricted or trekking zone, pending review", tag: "Permits", request: PermitApplicationRequest{}, response: PermitApplication{}, status: http.StatusAccepted},
+	{method: http.MethodGet, path: "/permits/applications/:id", summary: "Get a permit application and its review", tag: "Permits", response: PermitApplication{}, status: http.StatusOK},
+	{method: http.MethodPost, path: "/permits/applications/:id/approve", summary: "Approve a permit application and issue the permit on the ledger", tag: "Permits", request: PermitReviewRequest{}, response: PermitApplication{}, status: http.StatusCreated, committed: true},
+	{method: http.MethodPost, path: "/permits/applications/:id/reject", summary: "Reject a permit application", tag: "Permits", request: PermitReviewRequest{}, response: PermitApplication{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/permits/review", summary: "List permit applications awaiting review, oldest first", tag: "Permits", response: []PermitApplication{}, status: http.StatusOK},
+	{method: http.MethodPost, path: "/permits/verify", summary: "Check at a checkpoint that a tourist's DID is valid and a permit admits them to the zone now", tag: "Permits", request: PermitCheckRequest{}, response: PermitVerification{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/permits/tourist/:digitalId", summary: "List a tourist's permits, latest issued first", tag: "Permits", response: []PermitDocument{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/permits/:id", summary: "Get a permit as recorded on the ledger", tag: "Permits", response: PermitDocument{}, status: http.StatusOK},
+	{method: http.MethodPost, path: "/permits/:id/revoke", summary: "Revoke a permit before it lapses; only the issuing organization may", tag: "Permits", request: RevokePermitRequest{}, response: mutationResult{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/operators/groups", summary: "List the tour groups of the caller's organization", tag: "Tour Operators", response: []OperatorGroup{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/operators/groups/:id", summary: "Get a tour group", tag: "Tour Operators", response: OperatorGroup{}, status: http.StatusOK},
+	{method: http.MethodPut, path: "/operators/groups/:id", summary: "Create or replace a tour group with its members and geofence", tag: "Tour Operators", request: OperatorGroupRequest{}, response: OperatorGroup{}, status: http.StatusOK},
+	{method: http.MethodDelete, path: "/operators/groups/:id", summary: "Delete a tour group and its alerts", tag: "Tour Operators", response: mutationResult{}, status: http.StatusOK},
+	{method: http.MethodPost, path: "/operators/groups/:id/dids", summary: "Issue DIDs from a JSON, CSV or multipart manifest and add the members to the group", tag: "Tour Operators", request: []CreateDIDRequest{}, response: OperatorGroupDIDs{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/operators/groups/:id/status", summary: "Sum up the safety scores, positions and recent alerts of a group's members", tag: "Tour Operators", response: OperatorGroupStatus{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/operators/groups/:id/alerts", summary: "List a group's SOS, anomaly and geofence alerts, newest first", tag: "Tour Operators", response: []OperatorAlert{}, status: http.StatusOK},
+	{method: http.MethodPost, path: "/lost-found/items", summary: "Report an item lost by a tourist or handed in at a help center", tag: "Lost and Found", request: LostFoundItemRequest{}, response: mutationResult{}, status: http.StatusCreated, committed: true},
+	{method: http.MethodGet, path: "/lost-found/items", summary: "Search lost and found items by kind, category, status, words, distance and time", tag: "Lost and Found", query: LostFoundSearchRequest{}, response: []LostFoundItem{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/lost-found/items/:id", summary: "Get a lost or found item as recorded on the ledger", tag: "Lost and Found", response: LostFoundItem{}, status: http.StatusOK},
+	{method: http.MethodPost, path: "/lost-found/items/:id/photos", summary: "Upload a photo of an item and anchor its hash; only the reporting organization may", tag: "Lost and Found", request: LostFoundPhotoRequest{}, response: mutationResult{}, status: http.StatusCreated, committed: true, multipart: true},
+	{method: http.MethodGet, path: "/lost-found/items/:id/photos/:hash", summary: "Download a photo of an item, checked against its anchored hash", tag: "Lost and Found", status: http.StatusOK, binary: "image/jpeg"},
+	{method: http.MethodGet, path: "/lost-found/items/:id/candidates", summary: "Rank the open items of the other kind that may be the same item", tag: "Lost and Found", response: []LostFoundCandidate{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/lost-found/items/:id/matches", summary: "List the match resolutions involving an item, latest first", tag: "Lost and Found", response: []LostFoundMatch{}, status: http.StatusOK},
+	{method: http.MethodPost, path: "/lost-found/items/:id/close", summary: "Close an open item without a match; only the reporting organization may", tag: "Lost and Found", request: CloseLostFoundItemRequest{}, response: mutationResult{}, status: http.StatusOK, committed: true},
+	{method: http.MethodPost, path: "/lost-found/matches", summary: "Resolve a lost and a found item as returned to the tourist or as not the same item", tag: "Lost and Found", request: LostFoundMatchRequest{}, response: mutationResult{}, status: http.StatusCreated, committed: true},
+	{method: http.MethodGet, path: "/lost-found/matches/:id", summary: "Get a match resolution as recorded on the ledger", tag: "Lost and Found", response: LostFoundMatch{}, status: http.StatusOK},
+	{method: http.MethodPost, path: "/claims", summary: "Register an insurer's claim against an incident and the evidence it cites, notifying the tourist", tag: "Claims", request: RegisterClaimRequest{}, response: mutationResult{}, status: http.StatusCreated, committed: true},
+	{method: http.MethodGet, path: "/claims/:id", summary: "Get a claim reference as recorded on the ledger", tag: "Claims", response: ClaimReference{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/claims/:id/verify", summary: "Check that the incident and evidence a claim references are unchanged on the ledger", tag: "Claims", query: ClaimVerificationRequest{}, response: ClaimVerification{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/claims/incident/:incidentId", summary: "List the claim references registered against an incident, latest first", tag: "Claims", response: []ClaimReference{}, status: http.StatusOK},
+	{method: http.MethodPost, path: "/missing-persons", summary: "Open the case of a missing person incident, drawing the search area and notifying the units covering it", tag: "Missing Persons", request: OpenMissingPersonRequest{}, response: MissingPersonCase{}, status: http.StatusCreated},
+	{method: http.MethodGet, path: "/missing-persons/:incidentId", summary: "Get a missing person case with its evidence, ranked search sectors, notified units and stages", tag: "Missing Persons", response: MissingPersonCase{}, status: http.StatusOK},
+	{method: http.MethodPost, path: "/missing-persons/:incidentId/stages", summary: "Record a sighting, revise the search area, or mark the tourist found or the case closed, anchoring the stage", tag: "Missing Persons", request: CaseStageRequest{}, response: MissingPersonCase{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/missing-persons/:incidentId/anchors", summary: "List the stage digests of a missing person case anchored on the ledger", tag: "Missing Persons", response: []CaseAnchor{}, status: http.StatusOK},
+	{method: http.MethodPut, path: "/itinerary/:digitalId", summary: "Declare the route and stay points a tourist plans, anchoring the itinerary's digest, for route deviation alerts", tag: "Anomalies", request: ItineraryRequest{}, response: Itinerary{}, status: http.StatusOK, committed: true},
+	{method: http.MethodGet, path: "/itinerary/:digitalId", summary: "Get a tourist's declared itinerary", tag: "Anomalies", response: Itinerary{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/itinerary/:digitalId/deviation", summary: "Compare a tourist's live location with their itinerary", tag: "Anomalies", response: ItineraryDeviation{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/itinerary/:digitalId/anchors", summary: "List the itinerary digests anchored for a tourist", tag: "Anomalies", response: []ItineraryAnchor{}, status: http.StatusOK},
+	{method: http.MethodDelete, path: "/itinerary/:digitalId", summary: "Delete a tourist's declared itinerary", tag: "Anomalies", response: mutationResult{}, status: http.StatusOK},
+	{method: http.MethodPost, path: "/contacts/:digitalId", summary: "Register an emergency contact for a tourist and send verification codes to its addresses", tag: "SOS", request: ContactRequest{}, response: ContactRegistration{}, status: http.StatusCreated},
+	{method: http.MethodGet, path: "/contacts/:digitalId", summary: "List a tourist's emergency contacts in priority order", tag: "SOS", response: []RegisteredContact{}, status: http.StatusOK},
+	{method: http.MethodPut, path: "/contacts/:digitalId/:contactId", summary: "Replace an emergency contact's details, preferences or priority; changed addresses are verified again", tag: "SOS", request: ContactRequest{}, response: ContactRegistration{}, status: http.StatusOK},
+	{method: http.MethodDelete, path: "/contacts/:digitalId/:contactId", summary: "Remove an emergency contact", tag: "SOS", request: DeleteRequest{}, response: mutationResult{}, status: http.StatusOK},
+	{method: http.MethodPost, path: "/contacts/:digitalId/:contactId/code", summary: "Send a new verification code to an emergency contact's phone or email", tag: "SOS", request: ContactCodeRequest{}, response: ContactRegistration{}, status: http.StatusOK},
+	{method: http.MethodPost, path: "/contacts/:digitalId/:contactId/verify", summary: "Verify an emergency contact's phone or email with the code it received", tag: "SOS", request: VerifyContactRequest{}, response: RegisteredContact{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/safety/:digitalId", summary: "Get a tourist's safety score with the risks behind it, rescoring a stale one at the live location", tag: "Safety", response: SafetyScore{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/safety/:digitalId/anchors", summary: "List the safety scores anchored on the ledger for a tourist", tag: "Safety", response: []SafetyAnchor{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/decisions/:alertId", summary: "Get the anchored model decision behind an anomaly or safety drop, with the features it was made on", tag: "Safety", response: DecisionProvenance{}, status: http.StatusOK},
+	{method: http.MethodPost, path: "/decisions/:alertId/verify", summary: "Check features against the hash anchored with a model decision", tag: "Safety", request: VerifyFeaturesRequest{}, response: FeatureVerification{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/decisions/tourist/:digitalId", summary: "List the model decisions anchored for a tourist, latest first", tag: "Safety", response: []ModelDecision{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/weather/alerts", summary: "List active weather alerts, most severe first", tag: "Safety", response: []WeatherAlert{}, status: http.StatusOK},
+	{method: http.MethodPut, path: "/weather/alerts/:id", summary: "Publish or replace a weather alert over an area", tag: "Safety", request: WeatherAlertRequest{}, response: WeatherAlert{}, status: http.StatusOK},
+	{method: http.MethodDelete, path: "/weather/alerts/:id", summary: "Withdraw a weather alert", tag: "Safety", response: mutationResult{}, status: http.StatusOK},
+	{method: http.MethodPost, path: "/weather/advisories", summary: "Ingest a CAP 1.2 advisory (XML body) as a weather alert, raising the risk of the zones it covers, alerting the tourists inside them and anchoring its digest", tag: "Safety", query: AdvisoryIngestRequest{}, response: AdvisoryIngestion{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/weather/advisories/:id/anchors", summary: "List the digests anchored on the ledger for an advisory", tag: "Safety", response: []AdvisoryAnchor{}, status: http.StatusOK},
+	{method: http.MethodPost, path: "/broadcasts", summary: "Warn the tourists recently seen near an acknowledged high-severity incident or an active advisory, recording the decision on the ledger before pushing", tag: "Safety", request: BroadcastRequest{}, response: BroadcastResult{}, status: http.StatusCreated},
+	{method: http.MethodGet, path: "/broadcasts", summary: "List the broadcasts recorded for an incident or advisory, latest first", tag: "Safety", query: BroadcastListRequest{}, response: []Broadcast{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/broadcasts/:id", summary: "Get a broadcast decision as recorded on the ledger", tag: "Safety", response: Broadcast{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/broadcasts/opt-outs/:digitalId", summary: "Check whether a tourist has opted out of proximity broadcasts", tag: "Safety", response: BroadcastOptOut{}, status: http.StatusOK},
+	{method: http.MethodPut, path: "/broadcasts/opt-outs/:digitalId", summary: "Opt a tourist out of proximity broadcasts", tag: "Safety", response: BroadcastOptOut{}, status: http.StatusOK},
+	{method: http.MethodDelete, path: "/broadcasts/opt-outs/:digitalId", summary: "Opt a tourist back in to proximity broadcasts", tag: "Safety", response: BroadcastOptOut{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/dispatch/assignments", summary: "List the unit assignments for an SOS alert or incident, oldest first", tag: "Dispatch", query: AssignmentListRequest{}, response: []AssignmentDocument{}, status: http.StatusOK},
+	{method: http.MethodPost, path: "/dispatch/assignments/:id/acknowledge", summary: "Acknowledge an assignment as the assigned unit, stopping its escalation", tag: "Dispatch", request: AcknowledgeAssignmentRequest{}, response: mutationResult{}, status: http.StatusOK, committed: true},
+	{method: http.MethodPost, path: "/dispatch/assignments/:id/decline", summary: "Decline an assignment as the assigned unit, passing it to the next unit at once", tag: "Dispatch", request: DeclineAssignmentRequest{}, response: mutationResult{}, status: http.StatusOK, committed: true},
+	{method: http.MethodGet, path: "/dispatch/units", summary: "List responder units with their kind, roster status, shifts, capabilities and live position", tag: "Dispatch", response: []DispatchUnit{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/dispatch/units/nearest", summary: "List the available, on-shift units nearest a point, optionally of a kind and with given capabilities", tag: "Dispatch", query: NearestUnitsRequest{}, response: []NearbyUnit{}, status: http.StatusOK},
+	{method: http.MethodPut, path: "/dispatch/units/:id/status", summary: "Mark a unit available, busy or off duty", tag: "Dispatch", request: UnitStatusRequest{}, response: DispatchUnit{}, status: http.StatusOK},
+	{method: http.MethodPut, path: "/dispatch/units/:id/roster", summary: "Replace a unit's weekly shifts and capabilities", tag: "Dispatch", request: UnitRosterRequest{}, response: DispatchUnit{}, status: http.StatusOK},
+	{method: http.MethodPut, path: "/dispatch/units/:id/position", summary: "Report a mobile unit's live position", tag: "Dispatch", request: UnitPositionRequest{}, response: DispatchUnit{}, status: http.StatusOK},
+	{method: http.MethodPost, path: "/dispatch/units/:id/positions", summary: "Upload a batch of a unit's GPS fixes to its track, moving its live position to the newest", tag: "Dispatch", request: UnitFixesRequest{}, response: UnitFixesResult{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/dispatch/units/:id/track", summary: "Read a unit's recorded track over a window for after-action review", tag: "Dispatch", query: UnitTrackRequest{}, response: UnitTrack{}, status: http.StatusOK},
+	{method: http.MethodPost, path: "/erasures", summary: "Erase a tourist's off-chain personal data on their verified request, recording the erasure on the ledger and returning a signed certificate", tag: "Consent", request: ErasureRequest{}, response: ErasureResult{}, status: http.StatusCreated, committed: true},
+	{method: http.MethodGet, path: "/erasures", summary: "List the erasures recorded for a DID", tag: "Consent", query: ErasureListRequest{}, response: []ErasureRecord{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/erasures/:id/certificate", summary: "Get the signed certificate of an erasure recorded on the ledger", tag: "Consent", response: ErasureResult{}, status: http.StatusOK},
+	{method: http.MethodPost, path: "/reports", summary: "Generate the daily or weekly operations report for a period that has ended, storing its PDF and CSV, anchoring their digests and emailing them", tag: "Reports", request: GenerateReportRequest{}, response: GeneratedReport{}, status: http.StatusCreated, committed: true},
+	{method: http.MethodGet, path: "/reports", summary: "List the anchored reports of a period, newest first", tag: "Reports", query: ReportListRequest{}, response: []ReportAnchor{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/reports/:id/:format", summary: "Download a report as pdf or csv after checking its anchored hash", tag: "Reports", status: http.StatusOK, binary: "application/octet-stream"},
+	{method: http.MethodGet, path: "/jobs", summary: "List the recurring maintenance jobs with their schedules and how their last runs went", tag: "Jobs", response: []JobStatus{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/jobs/:name", summary: "Get a maintenance job's schedule, next run and last run", tag: "Jobs", response: JobStatus{}, status: http.StatusOK},
+	{method: http.MethodPost, path: "/jobs/:name/run", summary: "Run a maintenance job now on this instance, answering once it has started (409 JOB_RUNNING while a run is in progress)", tag: "Jobs", request: RunJobRequest{}, response: JobRun{}, status: http.StatusAccepted},
+	{method: http.MethodGet, path: "/sla/compliance", summary: "Rolling compliance with the acknowledgement and resolution targets of each severity, by district", tag: "Reports", query: SLAComplianceRequest{}, response: SLACompliance{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/sla/breaches", summary: "List the SLA breaches recorded on the ledger, latest first", tag: "Reports", query: SLABreachListRequest{}, response: []SLABreach{}, status: http.StatusOK},
+	{method: http.MethodPost, path: "/wearables", summary: "Register a safety band by its serial, with the Ed25519 key it signs its telemetry with", tag: "Wearables", request: RegisterWearableRequest{}, response: mutationResult{}, status: http.StatusCreated, committed: true},
+	{method: http.MethodGet, path: "/wearables/:deviceId", summary: "Get a registered safety band and the tourist it is bound to", tag: "Wearables", response: DeviceDocument{}, status: http.StatusOK},
+	{method: http.MethodPost, path: "/wearables/:deviceId/bind", summary: "Bind an unbound safety band to a tourist, recording the binding on the ledger", tag: "Wearables", request: BindWearableRequest{}, response: mutationResult{}, status: http.StatusOK, committed: true},
+	{method: http.MethodPost, path: "/wearables/:deviceId/unbind", summary: "Unbind a safety band from its tourist, after which its telemetry is dropped", tag: "Wearables", request: DeleteRequest{}, response: mutationResult{}, status: http.StatusOK, committed: true},
+	{method: http.MethodGet, path: "/wearables/:deviceId/bindings", summary: "List a safety band's bindings and unbindings in sequence", tag: "Wearables", response: []DeviceBinding{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/wearables/:deviceId/telemetry", summary: "List a safety band's recent normalized telemetry, newest first", tag: "Wearables", query: WearableTelemetryRequest{}, response: WearableTelemetryPage{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/helpline/calls/:id", summary: "Get an anchored helpline call: its incident, outcome, answering unit and recording hash", tag: "Helpline", response: CallDocument{}, status: http.StatusOK},
+	{method: http.MethodPost, path: "/push/devices", summary: "Register a device token for SOS and high-severity incident push alerts, or a tourist's device for the weather advisories of the zones they are in", tag: "Push Notifications", request: RegisterDeviceRequest{}, response: PushDevice{}, status: http.StatusCreated},
+	{method: http.MethodDelete, path: "/push/devices", summary: "Unregister a push device token", tag: "Push Notifications", request: UnregisterDeviceRequest{}, response: mutationResult{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/notifications/email/:userId", summary: "Get whether a user receives incident update emails", tag: "Notifications", response: EmailPreference{}, status: http.StatusOK},
+	{method: http.MethodPut, path: "/notifications/email/:userId", summary: "Opt a user out of, or back into, incident update emails", tag: "Notifications", request: UpdateEmailPreferenceRequest{}, response: EmailPreference{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/notifications/escalations/:id", summary: "Get an escalation case with every delivery made along its chain", tag: "Notifications", response: EscalationCase{}, status: http.StatusOK},
+	{method: http.MethodPost, path: "/notifications/escalations/:id/acknowledge", summary: "Acknowledge an escalation case, stopping it from moving down its chain", tag: "Notifications", request: AcknowledgeEscalationRequest{}, response: EscalationCase{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/workflows/rules", summary: "List the newest version of every workflow rule, optionally for one chaincode event", tag: "Workflows", response: []WorkflowRule{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/workflows/rules/:id", summary: "Get a workflow rule's newest version, or an earlier one with ?version=", tag: "Workflows", response: WorkflowRule{}, status: http.StatusOK},
+	{method: http.MethodPut, path: "/workflows/rules/:id", summary: "Save a new version of a rule mapping a chaincode event to zone unit pushes, dashboard tickets and notifications", tag: "Workflows", request: WorkflowRuleRequest{}, response: WorkflowRule{}, status: http.StatusOK},
+	{method: http.MethodDelete, path: "/workflows/rules/:id", summary: "Delete a workflow rule with all its versions", tag: "Workflows", response: mutationResult{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/workflows/rules/:id/versions", summary: "List the kept versions of a workflow rule, newest first", tag: "Workflows", response: []WorkflowRule{}, status: http.StatusOK},
+	{method: http.MethodPost, path: "/workflows/rules/:id/rollback", summary: "Save an earlier version of a workflow rule again as its newest", tag: "Workflows", request: WorkflowRollbackRequest{}, response: WorkflowRule{}, status: http.StatusOK},
+	{method: http.MethodPost, path: "/workflows/dry-run", summary: "Evaluate a sample event against the enabled rules or a draft rule and return the actions they would take, without taking them", tag: "Workflows", request: WorkflowDryRunRequest{}, response: WorkflowEvaluation{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/workflows/tickets", summary: "List the dashboard tickets workflow rules opened, newest first", tag: "Workflows", query: WorkflowTicketListRequest{}, response: []WorkflowTicket{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/workflows/tickets/:id", summary: "Get a workflow ticket", tag: "Workflows", response: WorkflowTicket{}, status: http.StatusOK},
+	{method: http.MethodPost, path: "/workflows/tickets/:id/acknowledge", summary: "Acknowledge a workflow ticket, taking it off the open list", tag: "Workflows", request: WorkflowTicketRequest{}, response: WorkflowTicket{}, status: http.StatusOK},
+	{method: http.MethodPost, path: "/kyc/applications", summary: "Submit a passport or Aadhaar application, as JSON or multipart with artifacts; the DID is issued once verified (201) or the application queued for review (202)", tag: "KYC", request: KYCApplicationRequest{}, response: KYCApplication{}, status: http.StatusCreated, committed: true},
+	{method: http.MethodGet, path: "/kyc/applications/:id", summary: "Get a KYC application", tag: "KYC", response: KYCApplication{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/kyc/applications/:id/artifacts/:name", summary: "Download a verification artifact after checking its recorded hash", tag: "KYC", status: http.StatusOK, binary: "application/octet-stream"},
+	{method: http.MethodPost, path: "/kyc/applications/:id/approve", summary: "Approve an application awaiting review and issue its DID", tag: "KYC", request: KYCReviewRequest{}, response: KYCApplication{}, status: http.StatusCreated, committed: true},
+	{method: http.MethodPost, path: "/kyc/applications/:id/reject", summary: "Reject an application awaiting review", tag: "KYC", request: KYCReviewRequest{}, response: KYCApplication{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/kyc/review", summary: "List the applications awaiting manual review, oldest first", tag: "KYC", response: []KYCApplication{}, status: http.StatusOK},
+	{method: http.MethodPost, path: "/sync", summary: "Apply operations a device queued while offline, deduplicated by client operation ID and against the ledger, and reconcile the app with the server", tag: "Sync", request: SyncRequest{}, response: SyncResponse{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/sync", summary: "Changes to incidents, their evidence and unit assignments in the caller's districts since a cursor, for patrol apps on slow links", tag: "Sync", query: DeltaSyncRequest{}, response: DeltaSync{}, status: http.StatusOK},
+	{method: http.MethodPost, path: "/grants", summary: "Grant another organization read access to one of the caller's incidents and optionally its evidence until expiresAt, recorded on the ledger", tag: "Grants", request: CreateOrgGrantRequest{}, response: mutationResult{}, status: http.StatusCreated},
+	{method: http.MethodGet, path: "/grants", summary: "List the access grants the caller's organization gave or received, newest first", tag: "Grants", query: OrgGrantListRequest{}, response: []AccessGrant{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/grants/:id", summary: "Read an access grant the caller's organization gave or received", tag: "Grants", response: AccessGrant{}, status: http.StatusOK},
+	{method: http.MethodPost, path: "/grants/:id/revoke", summary: "Revoke an access grant before it expires; only the granting organization may", tag: "Grants", request: DeleteRequest{}, response: mutationResult{}, status: http.StatusOK},
+	{method: http.MethodPost, path: "/shares", summary: "Create a time-boxed link letting a family member see a tourist's coarse location and safety status; requires family_sharing consent and, with passkeys enabled, an assertion for share.create", tag: "Shares", request: CreateShareRequest{}, response: CreatedShare{}, status: http.StatusCreated},
+	{method: http.MethodGet, path: "/shares", summary: "List a tourist's share links with their view counts", tag: "Shares", query: ShareListRequest{}, response: []FamilyShare{}, status: http.StatusOK},
+	{method: http.MethodPost, path: "/shares/:id/revoke", summary: "Revoke a share link", tag: "Shares", request: DeleteRequest{}, response: FamilyShare{}, status: http.StatusOK},
+	{method: http.MethodPost, path: "/credentials", summary: "Issue a tourist identity, trek permit or insurance coverage credential as a VC-JWT bound to a valid DID", tag: "Credentials", request: IssueCredentialRequest{}, response: IssuedCredentialResponse{}, status: http.StatusCreated},
+	{method: http.MethodGet, path: "/credentials", summary: "List the credentials issued to a DID", tag: "Credentials", query: CredentialListRequest{}, response: []IssuedCredential{}, status: http.StatusOK},
+	{method: http.MethodPost, path: "/credentials/:id/revoke", summary: "Revoke a credential by setting its status list bit", tag: "Credentials", request: DeleteRequest{}, response: IssuedCredential{}, status: http.StatusOK},
+	{method: http.MethodPost, path: "/webauthn/:digitalId/registration/options", summary: "Issue a challenge for registering a passkey to a DID", tag: "Passkeys", response: CredentialCreationOptions{}, status: http.StatusOK},
+	{method: http.MethodPost, path: "/webauthn/:digitalId/registration", summary: "Register a passkey to a DID; once the DID has one, X-WebAuthn-Assertion must carry an assertion for passkey.register", tag: "Passkeys", request: RegisterPasskeyRequest{}, response: Passkey{}, status: http.StatusCreated},
+	{method: http.MethodPost, path: "/webauthn/:digitalId/assertion/options", summary: "Issue a challenge for asserting a sensitive action, sent back in X-WebAuthn-Assertion", tag: "Passkeys", request: AssertionOptionsRequest{}, response: CredentialRequestOptions{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/webauthn/:digitalId/credentials", summary: "List the passkeys registered to a DID", tag: "Passkeys", response: []Passkey{}, status: http.StatusOK},
+	{method: http.MethodDelete, path: "/webauthn/:digitalId/credentials/:credentialId", summary: "Remove a passkey; X-WebAuthn-Assertion must carry an assertion for passkey.delete", tag: "Passkeys", response: mutationResult{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/erss/exchanges/:subjectId", summary: "List the anchored exchanges with 112 ERSS about an SOS or incident", tag: "ERSS", response: []ERSSExchange{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/erss/failed", summary: "List the SOS alerts and incidents that could not be pushed to 112 ERSS", tag: "ERSS", response: []ERSSPush{}, status: http.StatusOK},
+	{method: http.MethodPost, path: "/erss/failed/:id/retry", summary: "Queue a failed 112 ERSS push again with its attempts reset", tag: "ERSS", response: ERSSPush{}, status: http.StatusAccepted},
+	{method: http.MethodGet, path: "/consents/:id", summary: "List a tourist's consents, one per purpose", tag: "Consent", response: []ConsentDocument{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/consents/:id/check", summary: "Check whether a tourist has an active consent to a purpose before processing their data", tag: "Consent", query: ConsentCheckRequest{}, response: ConsentCheck{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/consents/:id/history", summary: "Read every version of a consent and verify its hash chain", tag: "Consent", query: ConsentCheckRequest{}, response: ConsentHistory{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/consents/:id/receipts", summary: "List the consent receipts issued to a tourist, with the hashes anchored for them", tag: "Consent", query: ConsentReceiptListRequest{}, response: []ConsentReceiptDocument{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/consents/:id/receipts/:receiptId", summary: "Get a consent receipt as JSON or PDF (format=json|pdf), verified against its ledger anchor and the consent's hash chain", tag: "Consent", query: ConsentReceiptRequest{}, response: IssuedConsentReceipt{}, status: http.StatusOK},
+	{method: http.MethodPost, path: "/consents/:id/receipts", summary: "Issue the receipt for a consent's current version again, when it failed to issue with the change or was lost from storage", tag: "Consent", request: IssueConsentReceiptRequest{}, response: IssuedConsentReceipt{}, status: http.StatusOK, committed: true},
+	{method: http.MethodPost, path: "/consents/:id/grant", summary: "Grant consent to a purpose", tag: "Consent", request: GrantConsentRequest{}, response: ConsentChange{}, status: http.StatusOK, committed: true},
+	{method: http.MethodPost, path: "/consents/:id/renew", summary: "Extend a granted consent", tag: "Consent", request: RenewConsentRequest{}, response: ConsentChange{}, status: http.StatusOK, committed: true},
+	{method: http.MethodPost, path: "/consents/:id/withdraw", summary: "Withdraw a consent; withdrawing data_retention deletes the tourist's KYC artifacts; with passkeys enabled, needs an assertion for consent.withdraw", tag: "Consent", request: WithdrawConsentRequest{}, response: ConsentChange{}, status: http.StatusOK, committed: true},
+
+	{method: http.MethodPost, path: "/evidence/", summary: "Anchor evidence by hash", tag: "Evidence", request: CreateEvidenceRequest{}, response: mutationResult{}, status: http.StatusCreated, committed: true},
+	{method: http.MethodPost, path: "/evidence/upload", summary: "Upload an evidence file and anchor its hash", tag: "Evidence", request: UploadEvidenceRequest{}, response: mutationResult{}, status: http.StatusCreated, committed: true, multipart: true},
+	{method: http.MethodGet, path: "/evidence/:id", summary: "Get evidence", tag: "Evidence", response: EvidenceDocument{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/evidence/:id/download", summary: "Download a verified evidence file", tag: "Evidence", status: http.StatusOK, binary: "application/octet-stream"},
+	{method: http.MethodGet, path: "/evidence/:id/derivatives", summary: "List the thumbnail, preview and sanitized copies derived from an evidence file, each anchored with its parent hash", tag: "Evidence", response: []EvidenceDerivative{}, status: http.StatusOK},
+	{method: http.MethodPost, path: "/evidence/:id/derivatives", summary: "Queue an uploaded evidence file for the media pipeline again", tag: "Evidence", response: EvidenceMediaQueued{}, status: http.StatusAccepted},
+	{method: http.MethodGet, path: "/evidence/:id/derivatives/:kind", summary: "Download a verified copy derived from an evidence file", tag: "Evidence", status: http.StatusOK, binary: "application/octet-stream"},
+	{method: http.MethodPut, path: "/evidence/:id", summary: "Update evidence", tag: "Evidence", request: UpdateEvidenceRequest{}, response: mutationResult{}, status: http.StatusOK, committed: true},
+	{method: http.MethodDelete, path: "/evidence/:id", summary: "Delete evidence", tag: "Evidence", request: DeleteRequest{}, response: mutationResult{}, status: http.StatusOK, committed: true},
+	{method: http.MethodGet, path: "/evidence/incident/:incidentId", summary: "List evidence for an incident, filtered by media type, uploader and creation time", tag: "Evidence", query: EvidenceListRequest{}, response: []EvidenceDocument{}, status: http.StatusOK},
+	{method: http.MethodPost, path: "/cctv/manifests", summary: "Anchor a municipal CCTV export manifest, recording each clip's hash as evidence on the incident", tag: "CCTV", request: CCTVManifestRequest{}, response: mutationResult{}, status: http.StatusCreated, committed: true},
+	{method: http.MethodGet, path: "/cctv/manifests/:id", summary: "Get an anchored CCTV export manifest", tag: "CCTV", response: CCTVManifestDocument{}, status: http.StatusOK},
+	{method: http.MethodPost, path: "/cctv/manifests/:id/clips/:clipId/verify", summary: "Hash a clip retrieved from the municipal system and check it against the anchored hash; nothing is stored", tag: "CCTV", request: struct{}{}, response: CCTVClipVerification{}, status: http.StatusOK, multipart: true},
+
+	{method: http.MethodGet, path: "/ledger/blocks/:number", summary: "Get a block header and the validation result of each transaction in it", tag: "Ledger", response: BlockInfo{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/tx/:txid/status", summary: "Check whether a transaction has committed, with its validation code and block number", tag: "Ledger", response: TransactionStatus{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/ledger/tx/:txid", summary: "Get a transaction, its validation code and the block that recorded it", tag: "Ledger", response: LedgerTxInfo{}, status: http.StatusOK},
+
+	{method: http.MethodPost, path: "/offline/proposals", summary: "Prepare an unsigned proposal for an external signer", tag: "Offline Signing", request: OfflineProposalRequest{}, response: OfflineStep{}, status: http.StatusOK},
+	{method: http.MethodPost, path: "/offline/endorse", summary: "Endorse a signed proposal and return the transaction to sign", tag: "Offline Signing", request: OfflineSignedRequest{}, response: OfflineStep{}, status: http.StatusOK},
+	{method: http.MethodPost, path: "/offline/submit", summary: "Submit a signed transaction and return the commit status request to sign", tag: "Offline Signing", request: OfflineSignedRequest{}, response: OfflineStep{}, status: http.StatusAccepted},
+	{method: http.MethodPost, path: "/offline/status", summary: "Check the commit status of an offline-signed transaction", tag: "Offline Signing", request: OfflineSignedRequest{}, response: TransactionStatus{}, status: http.StatusOK},
+
+	{method: http.MethodGet, path: "/targets", summary: "List the channels and chaincodes requests can be routed to", tag: "Ledger", response: TargetRegistry{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/canary", summary: "The split of traffic between the stable and canary chaincode, with each side's calls, errors and latency", tag: "Ledger", response: CanaryStatus{}, status: http.StatusOK},
+	{method: http.MethodPut, path: "/canary", summary: "Change the share of callers and the tenants routed to the canary chaincode", tag: "Ledger", request: UpdateCanaryRequest{}, response: CanaryStatus{}, status: http.StatusOK},
+	{method: http.MethodPost, path: "/canary/rollback", summary: "Send all traffic back to the stable chaincode at once", tag: "Ledger", request: RollbackCanaryRequest{}, response: CanaryStatus{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/network/peers", summary: "Show the peers the gateway connection fails over between, the peers and orderers discovery found on each channel, and which replica leads event processing", tag: "Network", response: NetworkTopology{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/network/health", summary: "Report ledger height and stalls per target, peer block lag, commit latency, chaincode error rates, event listener state and active network alerts", tag: "Network", response: FabricNetworkHealth{}, status: http.StatusOK},
+
+	{method: http.MethodGet, path: "/stats", summary: "Dashboard statistics: open incidents, SOS alerts, DIDs, time to acknowledge and evidence volume", tag: "Stats", response: DashboardStats{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/heatmap", summary: "Incident and SOS counts bucketed by geohash, for the dashboard map layer", tag: "Stats", query: HeatmapRequest{}, response: Heatmap{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/analytics/zones", summary: "Differentially private daily visitor and incident counts per zone, with counts below the threshold withheld (503 ANALYTICS_DISABLED unless configured)", tag: "Stats", query: AnalyticsRequest{}, response: AnalyticsExport{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/analytics/zones/export", summary: "Export the private zone counts as CSV or XLSX open data (format=csv|xlsx)", tag: "Stats", query: AnalyticsExportRequest{}, status: http.StatusOK, binary: "text/csv"},
+	{method: http.MethodGet, path: "/dashboard/counts", summary: "Live open incident counts per district; pass since=version to wait for the next change", tag: "Dashboard", query: LiveCountsRequest{}, response: LiveCounts{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/dashboard/clusters", summary: "Incidents clustered by proximity and time, largest first", tag: "Dashboard", query: ClusterRequest{}, response: IncidentClusters{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/dashboard/duplicates", summary: "Open reports of the same category close in place and time, with the incident to merge them into", tag: "Dashboard", query: DuplicatesRequest{}, response: DuplicateClusters{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/dashboard/responders", summary: "Responder units and their roster status, grouped by district", tag: "Dashboard", response: ResponderBoard{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/dashboard/units", summary: "Map layer of units at their live or station positions and open incidents, each with the nearest of the other", tag: "Dashboard", query: UnitLayerRequest{}, response: UnitLayer{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/dashboard/districts/:district", summary: "One district's open incidents, units and recent clusters", tag: "Dashboard", response: DistrictDetail{}, status: http.StatusOK},
+
+	{method: http.MethodGet, path: "/audit", summary: "Search audit logs by actor, action and period", tag: "Audit", query: AuditSearchRequest{}, response: AuditPage{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/audit/compliance", summary: "Verify a target's or actor's audit entries against the blocks that recorded them, summarize them by period and action, flag anomalies such as deletions outside working hours, and return the signed report as JSON or PDF (format=json|pdf, signature in X-Compliance-Signature)", tag: "Audit", query: ComplianceRequest{}, response: SignedComplianceReport{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/audit/export", summary: "Export audit logs over a period as CSV or XLSX (format=csv|xlsx)", tag: "Audit", query: AuditExportRequest{}, status: http.StatusOK, binary: "text/csv"},
+	{method: http.MethodGet, path: "/audit/:targetId", summary: "List audit logs for a target", tag: "Audit", response: []AuditDocument{}, status: http.StatusOK},
+
+	{method: http.MethodGet, path: "/export/:docType", summary: "Stream every document of a type (did, incident, evidence, efir, sos, location_anchor, safety_anchor, advisory_anchor, report_anchor, erasure, assignment, fir_allocation, zone or audit) as NDJSON, optionally keeping only include or dropping exclude fields", tag: "Audit", query: DocumentExportRequest{}, status: http.StatusOK, binary: "application/x-ndjson"},
+	{method: http.MethodPost, path: "/devices/keys", summary: "Enrol a device public key for request signing; an enrolled device rotates its key with a request signed by the current one", tag: "Request Signing", request: RegisterDeviceKeyRequest{}, response: DeviceKey{}, status: http.StatusCreated},
+	{method: http.MethodDelete, path: "/devices/keys/:deviceId", summary: "Revoke a device signing key", tag: "Request Signing", response: mutationResult{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/me/summary", summary: "Summarize a tourist's DID, active trip, live location, open incidents, safety score and nearby risk zones in one call", tag: "Tourist App", query: MeSummaryRequest{}, response: MeSummary{}, status: http.StatusOK},
+	{method: http.MethodPost, path: "/auth/token", summary: "Exchange the caller's API key for a short-lived access token and a rotating refresh token", tag: "Sessions", request: IssueTokenRequest{}, response: TokenResponse{}, status: http.StatusCreated},
+	{method: http.MethodPost, path: "/auth/refresh", summary: "Rotate a refresh token for a new token pair; reusing a rotated refresh token revokes its session", tag: "Sessions", request: RefreshTokenRequest{}, response: TokenResponse{}, status: http.StatusOK},
+	{method: http.MethodPost, path: "/auth/logout", summary: "Revoke the session of the access token the request carries", tag: "Sessions", response: loggedOut{}, status: http.StatusOK},
+	{method: http.MethodPost, path: "/auth/revoke-all", summary: "Revoke every session of the caller's wallet identity, or of another identity with delete on sessions", tag: "Sessions", request: RevokeSessionsRequest{}, response: revokedSessions{}, status: http.StatusOK},
+	{method: http.MethodPost, path: "/enrollments/requests", summary: "Ask the Fabric CA for a device or officer certificate, or a renewal, pending approval", tag: "Enrollment", request: SubmitEnrollmentRequest{}, response: IdentityEnrollment{}, status: http.StatusAccepted},
+	{method: http.MethodGet, path: "/enrollments/requests/:id", summary: "Get an enrollment request and, once approved, its certificate", tag: "Enrollment", response: IdentityEnrollment{}, status: http.StatusOK},
+	{method: http.MethodPost, path: "/enrollments/requests/:id/approve", summary: "Approve an enrollment request and have the CA issue its certificate", tag: "Enrollment", request: EnrollmentReviewRequest{}, response: IdentityEnrollment{}, status: http.StatusOK},
+	{method: http.MethodPost, path: "/enrollments/requests/:id/reject", summary: "Reject an enrollment request", tag: "Enrollment", request: EnrollmentReviewRequest{}, response: IdentityEnrollment{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/enrollments/review", summary: "List enrollment requests awaiting approval, oldest first", tag: "Enrollment", response: []IdentityEnrollment{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/admin/policy", summary: "Show the enforced access policy", tag: "Admin", response: accessPolicy{}, status: http.StatusOK},
+	{method: http.MethodPut, path: "/admin/policy", summary: "Replace the access policy and save it to RBAC_POLICY_FILE", tag: "Admin", request: AccessPolicyRequest{}, response: accessPolicy{}, status: http.StatusOK},
+	{method: http.MethodPost, path: "/admin/policy/reload", summary: "Reload the access policy from RBAC_POLICY_FILE", tag: "Admin", response: accessPolicy{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/admin/permissions", summary: "Show the roles, rules and routes granted to a wallet identity, an organization or the caller", tag: "Admin", query: PermissionsRequest{}, response: EffectivePermissions{}, status: http.StatusOK},
+	{method: http.MethodGet, path: "/admin/officers", summary: "List wallet identities with their bound roles", tag: "Admin", response: []Officer{}, status: http.StatusOK},
+	{method: http.MethodPost, path: "/admin/officers", summary: "Register and enroll an officer with the Fabric CA, add them to the wallet and bind their role", tag: "Admin", request: ProvisionOfficerRequest{}, response: ProvisionedOfficer{}, status: http.StatusCreated},
+	{method: http.MethodDelete, path: "/admin/officers/:label", summary: "Revoke a departing officer's certificates and remove their wallet identity, API keys and role bindings", tag: "Admin", request: DeprovisionOfficerRequest{}, response: DeprovisionedOfficer{}, status: http.StatusOK},
+	{method: http.MethodPost, path: "/admin/evidence-keys/rewrap", summary: "Rewrap every evidence data key under the current master key", tag: "Admin", response: EvidenceKeyRewrap{}, status: http.StatusOK},
+	{method: http.MethodPost, path: "/admin/evidence-keys/:incidentId/rotate", summary: "Re-encrypt an incident's evidence under a new data key", tag: "Admin", request: RotateEvidenceKeyRequest{}, response: EvidenceKeyRotation{}, status: http.StatusOK},
+}
+
+var (
+	openAPIOnce sync.Once
+	openAPIDoc  map[string]interface{}
+	pathParam   = regexp.MustCompile(`:(\w+)`)
+)
+
+// openAPISpec builds the OpenAPI 3 document once and reuses it
+func openAPISpec() map[string]interface{} {
+	openAPIOnce.Do(func() {
+		openAPIDoc = buildOpenAPISpec(apiOperations)
+	})
+	return openAPIDoc
+}
+
+func buildOpenAPISpec(operations []apiOperation) map[string]interface{} {
+	schemas := map[string]interface{}{}
+	schemaRef(schemas, reflect.TypeOf(APIResponse{}))
+
+	paths := map[string]interface{}{}
+	for _, op := range operations {
+		path := "/api/v1" + pathParam.ReplaceAllString(op.path, "{$1}")
+		item, ok := paths[path].(map[string]interface{})
+		if !ok {
+			item = map[string]interface{}{}
+			paths[path] = item
+		}
+		item[strings.ToLower(op.method)] = op.build(schemas)
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "Smart Tourist Safety Ledger API",
+			"version":     "1.0.0",
+			"description": "REST gateway to the Hyperledger Fabric ledger for digital IDs, incidents, evidence and audit logs.",
+		},
+		"paths":      paths,
+		"components": map[string]interface{}{"schemas": schemas},
+	}
+}
+
+func (op apiOperation) build(schemas map[string]interface{}) map[string]interface{} {
+	operation := map[string]interface{}{
+		"summary":     op.summary,
+		"tags":        []string{op.tag},
+		"operationId": strings.ToLower(op.method) + strings.NewReplacer("/", "_", ":", "").Replace(strings.TrimSuffix(op.path, "/")),
+	}
+
+	var parameters []interface{}
+	for _, match := range pathParam.FindAllStringSubmatch(op.path, -1) {
+		parameters = append(parameters, map[string]interface{}{
+			"name":     match[1],
+			"in":       "path",
+			"required": true,
+			"schema":   map[string]interface{}{"type": "string"},
+		})
+	}
+	if op.query != nil {
+		t := reflect.TypeOf(op.query)
+		for i := 0; i < t.NumField(); i++ {
+			parameters = append(parameters, map[string]interface{}{
+				"name":   t.Field(i).Tag.Get("form"),
+				"in":     "query",
+				"schema": schemaFor(schemas, t.Field(i).Type),
+			})
+		}
+	}
+	parameters = append(parameters, map[string]interface{}{
+		"name":        targetHeader,
+		"in":          "header",
+		"description": "Registered channel and chaincode to route to; the default target when omitted",
+		"schema":      map[string]interface{}{"type": "string"},
+	})
+	operation["parameters"] = parameters
+
+	switch {
+	case op.multipart:
+		form := schemaFor(schemas, reflect.TypeOf(op.request))
+		form["properties"].(map[string]interface{})[uploadFileField] = map[string]interface{}{"type": "string", "format": "binary"}
+		form["required"] = append(form["required"].([]string), uploadFileField)
+		operation["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content":  map[string]interface{}{"multipart/form-data": map[string]interface{}{"schema": form}},
+		}
+	case op.request != nil:
+		operation["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content":  jsonContent(schemaRef(schemas, reflect.TypeOf(op.request))),
+		}
+	}
+
+	responses := map[string]interface{}{
+		"400": errorResponse("Validation failed"),
+		"404": errorResponse("Document not found"),
+		"429": errorResponse("Rate limit exceeded"),
+		"503": errorResponse("Fabric peer unavailable or circuit open"),
+	}
+	if op.committed {
+		responses["409"] = errorResponse("Transaction failed validation or conflicted with another")
+	}
+	if op.multipart {
+		responses["413"] = errorResponse("File exceeds the upload limit")
+		responses["422"] = errorResponse("File failed the malware scan")
+	}
+	if op.binary != "" {
+		responses["200"] = map[string]interface{}{
+			"description": http.StatusText(op.status),
+			"content": map[string]interface{}{
+				op.binary: map[string]interface{}{"schema": map[string]interface{}{"type": "string", "format": "binary"}},
+			},
+		}
+	} else {
+		responses[fmt.Sprint(op.status)] = map[string]interface{}{
+			"description": http.StatusText(op.status),
+			"content": jsonContent(map[string]interface{}{
+				"allOf": []interface{}{
+					map[string]interface{}{"$ref": "#/components/schemas/APIResponse"},
+					map[string]interface{}{"properties": map[string]interface{}{"data": schemaRef(schemas, reflect.TypeOf(op.response))}},
+				},
+			}),
+		}
+	}
+	operation["responses"] = responses
+
+	return operation
+}
+
+func jsonContent(schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"application/json": map[string]interface{}{"schema": schema}}
+}
+
+func errorResponse(description string) map[string]interface{} {
+	return map[string]interface{}{
+		"description": description,
+		"content":     jsonContent(map[string]interface{}{"$ref": "#/components/schemas/APIResponse"}),
+	}
+}
+
+// schemaRef registers named structs as components and returns a reference, inlining everything else
+func schemaRef(schemas map[string]interface{}, t reflect.Type) map[string]interface{} {
+	if t.Kind() == reflect.Struct && t.Name() != "" {
+		if _, ok := schemas[t.Name()]; !ok {
+			schemas[t.Name()] = map[string]interface{}{}
+			schemas[t.Name()] = schemaFor(schemas, t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	return schemaFor(schemas, t)
+}
+
+func schemaFor(schemas map[string]interface{}, t reflect.Type) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return schemaRef(schemas, t.Elem())
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": schemaRef(schemas, t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaRef(schemas, t.Elem())}
+	case reflect.Struct:
+		properties := map[string]interface{}{}
+		required := []string{}
+		// Embedded structs are flattened into their parent, as encoding/json does
+		for _, field := range reflect.VisibleFields(t) {
+			if !field.IsExported() || field.Anonymous && field.Type.Kind() == reflect.Struct && field.Tag.Get("json") == "" {
+				continue
+			}
+			name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			properties[name] = schemaRef(schemas, field.Type)
+			if strings.Contains(field.Tag.Get("binding"), "required") {
+				required = append(required, name)
+			}
+		}
+		return map[string]interface{}{"type": "object", "properties": properties, "required": required}
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+// openAPIHandler serves the generated spec
+func openAPIHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, openAPISpec())
+}
+
+// swaggerUIHandler serves Swagger UI pointed at the generated spec
+func swaggerUIHandler(c *gin.Context) {
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(fmt.Sprintf(swaggerUIPage, swaggerUIVersion, swaggerUIVersion)))
+}
+
+const swaggerUIPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Smart Tourist Safety Ledger API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@%s/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@%s/swagger-ui-bundle.js" crossorigin></script>
+  <script>
+    window.onload = () => {
+      window.ui = SwaggerUIBundle({ url: "/openapi.json", dom_id: "#swagger-ui" });
+    };
+  </script>
+</body>
+</html>
+`
@@ -0,0 +1,38 @@
+package gateway
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestOpenAPIDocumentsEveryRoute(t *testing.T) {
+	t.Setenv("RATE_LIMIT_ENABLED", "false")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	paths := openAPISpec()["paths"].(map[string]interface{})
+	for _, route := range setupRouter(ctx).Routes() {
+		if !strings.HasPrefix(route.Path, "/api/v1/") {
+			continue
+		}
+		path := pathParam.ReplaceAllString(route.Path, "{$1}")
+		item, ok := paths[path].(map[string]interface{})
+		if !ok || item[strings.ToLower(route.Method)] == nil {
+			t.Errorf("%s %s is not in the OpenAPI spec", route.Method, route.Path)
+		}
+	}
+}
+
+func TestOpenAPISchemasFollowBindingTags(t *testing.T) {
+	schemas := openAPISpec()["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+
+	create := schemas["CreateDIDRequest"].(map[string]interface{})
+	required := strings.Join(create["required"].([]string), ",")
+	if required != "digitalID,consentHash,expiresAt,issuer" {
+		t.Errorf("CreateDIDRequest required = %s", required)
+	}
+	if _, ok := schemas["DIDDocument"].(map[string]interface{})["properties"].(map[string]interface{})["expires_at"]; !ok {
+		t.Error("DIDDocument schema is missing expires_at")
+	}
+}
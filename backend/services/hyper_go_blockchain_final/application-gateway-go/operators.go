@@ -0,0 +1,784 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+const (
+	operatorGroupsKey       = "sih:operators:groups"
+	operatorAlertsPrefix    = "sih:operators:alerts:"
+	operatorOutsidePrefix   = "sih:operators:outside:"
+	maxOperatorGroupMembers = 500
+	maxOperatorAlerts       = 200
+
+	operatorAlertSOS      = "sos"
+	operatorAlertAnomaly  = "anomaly"
+	operatorAlertGeofence = "left_geofence"
+	operatorAlertSafety   = "safety_drop"
+
+	safetyUnknown = "unknown"
+)
+
+// OperatorGroupRequest creates or replaces a tour operator's group. Geofence is the area the group
+// is expected to stay in, in the same shapes as a geofence zone.
+type OperatorGroupRequest struct {
+	Name     string           `json:"name" binding:"required"`
+	Members  []string         `json:"members"`
+	Geofence *GeoJSONGeometry `json:"geofence"`
+	Actor    string           `json:"actor" binding:"required"`
+}
+
+func (r OperatorGroupRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	if len(r.Name) > maxAlertRuleName {
+		v.add("name", "must be at most %d characters", maxAlertRuleName)
+	}
+	if len(r.Members) > maxOperatorGroupMembers {
+		v.add("members", "must contain at most %d tourists", maxOperatorGroupMembers)
+	}
+	seen := map[string]bool{}
+	for i, member := range r.Members {
+		v.digitalID(fmt.Sprintf("members[%d]", i), member)
+		if seen[member] {
+			v.add(fmt.Sprintf("members[%d]", i), "is listed twice")
+		}
+		seen[member] = true
+	}
+	if r.Geofence != nil {
+		if _, _, err := parseZoneGeometry(*r.Geofence); err != nil {
+			v.add("geofence", "%s", err.Error())
+		}
+	}
+	return v.errors
+}
+
+// OperatorGroup is a tour group managed by the operator organization that created it. Groups stay
+// off the ledger, like tourist groups; the DIDs issued for their members are on it.
+type OperatorGroup struct {
+	GroupID   string           `json:"group_id"`
+	Name      string           `json:"name"`
+	OwnerOrg  string           `json:"owner_org"`
+	Members   []string         `json:"members"`
+	Geofence  *GeoJSONGeometry `json:"geofence,omitempty"`
+	UpdatedBy string           `json:"updated_by"`
+	UpdatedAt string           `json:"updated_at"`
+}
+
+// OperatorGroupDIDs is a bulk issuance for a group and the group with the members added
+type OperatorGroupDIDs struct {
+	Report BulkDIDReport `json:"report"`
+	Group  OperatorGroup `json:"group"`
+}
+
+// OperatorAlert is a member of a group raising an SOS, tripping the anomaly detector or leaving
+// the group's geofence
+type OperatorAlert struct {
+	AlertID   string `json:"alert_id"`
+	GroupID   string `json:"group_id"`
+	Type      string `json:"type"`
+	DigitalID string `json:"digital_id"`
+	Severity  string `json:"severity"`
+	// An SOS carries the member's live location, since the ledger only holds a hash of its position
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Detail    string  `json:"detail"`
+	At        string  `json:"at"`
+	// SourceID is the SOS alert or anomaly behind the alert
+	SourceID string `json:"source_id,omitempty"`
+}
+
+// OperatorMemberStatus is one member's latest safety score and position. Reporting is whether the
+// position is no older than OPERATOR_STALE_AFTER.
+type OperatorMemberStatus struct {
+	DigitalID      string        `json:"digital_id"`
+	Level          string        `json:"level"`
+	Safety         *SafetyScore  `json:"safety,omitempty"`
+	Location       *LiveLocation `json:"location,omitempty"`
+	Reporting      bool          `json:"reporting"`
+	InsideGeofence *bool         `json:"inside_geofence,omitempty"`
+}
+
+// OperatorGroupStatus sums up a group's safety. Levels counts members by safety level, with
+// unknown for members without a score.
+type OperatorGroupStatus struct {
+	GroupID         string                 `json:"group_id"`
+	Name            string                 `json:"name"`
+	Members         int                    `json:"members"`
+	Reporting       int                    `json:"reporting"`
+	Silent          int                    `json:"silent"`
+	OutsideGeofence int                    `json:"outside_geofence"`
+	Levels          map[string]int         `json:"levels"`
+	LowestScore     *int                   `json:"lowest_score,omitempty"`
+	AverageScore    *float64               `json:"average_score,omitempty"`
+	RecentAlerts    int                    `json:"recent_alerts"`
+	MemberStatus    []OperatorMemberStatus `json:"member_status"`
+	CheckedAt       string                 `json:"checked_at"`
+}
+
+// operatorStore keeps groups, each group's recent alerts and the members outside its geofence. It
+// uses Redis when the document cache is configured, so every gateway instance sees the same groups.
+type operatorStore interface {
+	groups(ctx context.Context) (map[string]OperatorGroup, error)
+	putGroup(ctx context.Context, group OperatorGroup) error
+	removeGroup(ctx context.Context, groupID string) (bool, error)
+	// addAlert keeps the newest maxOperatorAlerts alerts of the group
+	addAlert(ctx context.Context, alert OperatorAlert) error
+	// alerts returns the group's alerts, newest first
+	alerts(ctx context.Context, groupID string) ([]OperatorAlert, error)
+	// setOutside records whether a member is outside the group's geofence and reports whether it
+	// changed
+	setOutside(ctx context.Context, groupID, digitalID string, outside bool) (bool, error)
+}
+
+// operatorService runs the tour operator portal: groups, their geofences, and the alerts their
+// members raise
+type operatorService struct {
+	store operatorStore
+	// staleAfter is how old a member's position may be and still count as reporting
+	staleAfter time.Duration
+	// recentAlerts is the window the status counts alerts over
+	recentAlerts time.Duration
+}
+
+var operators *operatorService
+
+// initOperators runs after initDocumentCache
+func initOperators() {
+	var store operatorStore = newMemoryOperatorStore()
+	backend := "memory"
+	if documentCache != nil {
+		store, backend = redisOperatorStore{documentCache}, "Redis"
+	}
+	operators = &operatorService{
+		store:        store,
+		staleAfter:   getEnvDuration("OPERATOR_STALE_AFTER", 30*time.Minute),
+		recentAlerts: getEnvDuration("OPERATOR_RECENT_ALERTS", 24*time.Hour),
+	}
+	log.Printf("🧑‍✈️ Tour operator groups kept in %s", backend)
+}
+
+// operatorAlertID is stable for an occurrence, so an alert seen by several gateway instances is
+// pushed once
+func operatorAlertID(groupID, digitalID, kind, source string, at time.Time) string {
+	sum := sha256.Sum256([]byte(groupID + "|" + digitalID + "|" + kind + "|" + source))
+	return "OPA-" + at.UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(sum[:5])
+}
+
+// groupArea parses a group's geofence for containment checks
+func groupArea(group OperatorGroup) (*indexedZone, error) {
+	geometry, err := json.Marshal(group.Geofence)
+	if err != nil {
+		return nil, err
+	}
+	return newIndexedZone(ZoneDocument{ZoneID: group.GroupID, Name: group.Name, Geometry: string(geometry)})
+}
+
+// memberGroups returns the groups a tourist belongs to
+func (o *operatorService) memberGroups(ctx context.Context, digitalID string) ([]OperatorGroup, error) {
+	all, err := o.store.groups(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var groups []OperatorGroup
+	for _, id := range sortedKeys(all) {
+		if slices.Contains(all[id].Members, digitalID) {
+			groups = append(groups, all[id])
+		}
+	}
+	return groups, nil
+}
+
+// ownedGroup reads a group the caller's organization manages
+func (o *operatorService) ownedGroup(ctx context.Context, groupID string) (OperatorGroup, error) {
+	all, err := o.store.groups(ctx)
+	if err != nil {
+		return OperatorGroup{}, err
+	}
+	group, ok := all[groupID]
+	if !ok || !tenantFromContext(ctx).owns(group.OwnerOrg) {
+		return OperatorGroup{}, fmt.Errorf("the tour group %s does not exist", groupID)
+	}
+	return group, nil
+}
+
+// track checks a member's live position against the geofences of their groups, and alerts the
+// operator when the member leaves one. Returning inside re-arms the alert.
+func (o *operatorService) track(ctx context.Context, digitalID string, live LiveLocation) {
+	groups, err := o.memberGroups(ctx, digitalID)
+	if err != nil {
+		log.Printf("🧑‍✈️ Failed to read tour groups of %s: %v", digitalID, err)
+		return
+	}
+	at, _ := time.Parse(time.RFC3339, live.RecordedAt)
+	for _, group := range groups {
+		if group.Geofence == nil {
+			continue
+		}
+		area, err := groupArea(group)
+		if err != nil {
+			log.Printf("🧑‍✈️ Tour group %s has an unusable geofence: %v", group.GroupID, err)
+			continue
+		}
+		outside := !area.contains(live.Longitude, live.Latitude)
+		changed, err := o.store.setOutside(ctx, group.GroupID, digitalID, outside)
+		if err != nil {
+			log.Printf("🧑‍✈️ Failed to record geofence state of %s in %s: %v", digitalID, group.GroupID, err)
+			continue
+		}
+		if !changed || !outside {
+			continue
+		}
+		o.alert(ctx, group, OperatorAlert{
+			AlertID:   operatorAlertID(group.GroupID, digitalID, operatorAlertGeofence, "", at),
+			Type:      operatorAlertGeofence,
+			DigitalID: digitalID,
+			Severity:  "medium",
+			Latitude:  live.Latitude,
+			Longitude: live.Longitude,
+			Detail:    "left the area of " + group.Name,
+			At:        live.RecordedAt,
+		})
+	}
+}
+
+// anomalies alerts the operators of the tourists the detector flagged, whatever the score
+func (o *operatorService) anomalies(ctx context.Context, events []AnomalyEvent) {
+	for _, e := range events {
+		groups, err := o.memberGroups(ctx, e.DigitalID)
+		if err != nil {
+			log.Printf("🧑‍✈️ Failed to read tour groups of %s: %v", e.DigitalID, err)
+			continue
+		}
+		at, _ := time.Parse(time.RFC3339, e.At)
+		for _, group := range groups {
+			o.alert(ctx, group, OperatorAlert{
+				AlertID:   operatorAlertID(group.GroupID, e.DigitalID, operatorAlertAnomaly, e.AnomalyID, at),
+				Type:      operatorAlertAnomaly,
+				DigitalID: e.DigitalID,
+				Severity:  anomalySeverity(e.Score),
+				Latitude:  e.Latitude,
+				Longitude: e.Longitude,
+				Detail:    e.Detail,
+				At:        e.At,
+				SourceID:  e.AnomalyID,
+			})
+		}
+	}
+}
+
+// safetyDrop alerts the operators of a tourist whose safety level fell
+func (o *operatorService) safetyDrop(ctx context.Context, alertID string, previous, score SafetyScore) {
+	groups, err := o.memberGroups(ctx, score.DigitalID)
+	if err != nil {
+		log.Printf("🧑‍✈️ Failed to read tour groups of %s: %v", score.DigitalID, err)
+		return
+	}
+	at, _ := time.Parse(time.RFC3339, score.ComputedAt)
+	severity := "medium"
+	if score.Level == "danger" {
+		severity = "high"
+	}
+	for _, group := range groups {
+		o.alert(ctx, group, OperatorAlert{
+			AlertID:   operatorAlertID(group.GroupID, score.DigitalID, operatorAlertSafety, alertID, at),
+			Type:      operatorAlertSafety,
+			DigitalID: score.DigitalID,
+			Severity:  severity,
+			Latitude:  score.Latitude,
+			Longitude: score.Longitude,
+			Detail:    fmt.Sprintf("safety score fell from %d (%s) to %d (%s)", previous.Score, previous.Level, score.Score, score.Level),
+			At:        score.ComputedAt,
+			SourceID:  alertID,
+		})
+	}
+}
+
+// operatorsFromEvent alerts the operators of a tourist who raised an SOS. Every gateway instance
+// sees the event; the first to claim it alerts.
+func operatorsFromEvent(ctx context.Context, event *client.ChaincodeEvent) {
+	if operators == nil || event.EventName != "RaiseSOS" {
+		return
+	}
+	var sos struct {
+		AlertID   string `json:"alert_id"`
+		DigitalID string `json:"digital_id"`
+		RaisedAt  string `json:"raised_at"`
+	}
+	if err := json.Unmarshal(event.Payload, &sos); err != nil || sos.DigitalID == "" {
+		return
+	}
+	if first, err := claimEvent(ctx, "operators:"+event.TransactionID, 24*time.Hour); err != nil || !first {
+		return
+	}
+	groups, err := operators.memberGroups(ctx, sos.DigitalID)
+	if err != nil {
+		log.Printf("🧑‍✈️ Failed to read tour groups of %s: %v", sos.DigitalID, err)
+		return
+	}
+	var live LiveLocation
+	if locations != nil {
+		if latest, err := locations.store.latest(ctx, sos.DigitalID); err == nil && latest != nil {
+			live = *latest
+		}
+	}
+	at, _ := time.Parse(time.RFC3339, sos.RaisedAt)
+	for _, group := range groups {
+		operators.alert(ctx, group, OperatorAlert{
+			AlertID:   operatorAlertID(group.GroupID, sos.DigitalID, operatorAlertSOS, sos.AlertID, at),
+			Type:      operatorAlertSOS,
+			DigitalID: sos.DigitalID,
+			Severity:  "critical",
+			Latitude:  live.Latitude,
+			Longitude: live.Longitude,
+			Detail:    "raised an SOS",
+			At:        sos.RaisedAt,
+			SourceID:  sos.AlertID,
+		})
+	}
+}
+
+// alert adds the alert to the group's feed and pushes it to the operator's devices subscribed to
+// the group ID
+func (o *operatorService) alert(ctx context.Context, group OperatorGroup, alert OperatorAlert) {
+	alert.GroupID = group.GroupID
+	log.Printf("🧑‍✈️ %s alert for %s in tour group %s: %s", alert.Type, alert.DigitalID, group.GroupID, alert.Detail)
+	if err := o.store.addAlert(ctx, alert); err != nil {
+		log.Printf("🧑‍✈️ Failed to record alert %s: %v", alert.AlertID, err)
+	}
+	if pusher == nil {
+		return
+	}
+	pusher.deliver(ctx, pushAlert{
+		key:   "operator:" + alert.AlertID,
+		zone:  group.GroupID,
+		org:   group.OwnerOrg,
+		title: group.Name,
+		body:  "Tourist " + alert.DigitalID + " " + alert.Detail,
+		data:  map[string]string{"type": "operator_alert", "alert": alert.Type, "alert_id": alert.AlertID, "group_id": group.GroupID, "digital_id": alert.DigitalID, "severity": alert.Severity, "at": alert.At},
+	})
+}
+
+// status reads each member's safety score and live position and sums them up
+func (o *operatorService) status(ctx context.Context, group OperatorGroup, now time.Time) (OperatorGroupStatus, error) {
+	status := OperatorGroupStatus{
+		GroupID:      group.GroupID,
+		Name:         group.Name,
+		Members:      len(group.Members),
+		Levels:       map[string]int{},
+		MemberStatus: make([]OperatorMemberStatus, 0, len(group.Members)),
+		CheckedAt:    now.UTC().Format(time.RFC3339),
+	}
+	var area *indexedZone
+	if group.Geofence != nil {
+		var err error
+		if area, err = groupArea(group); err != nil {
+			return status, err
+		}
+	}
+
+	scored, total := 0, 0
+	for _, digitalID := range group.Members {
+		member := OperatorMemberStatus{DigitalID: digitalID, Level: safetyUnknown}
+		if locations != nil {
+			live, err := locations.store.latest(ctx, digitalID)
+			if err != nil {
+				return status, err
+			}
+			member.Location = live
+		}
+		if safety != nil {
+			score, err := safety.current(ctx, digitalID)
+			if err != nil {
+				return status, err
+			}
+			if score != nil {
+				member.Safety, member.Level = score, score.Level
+				if status.LowestScore == nil || score.Score < *status.LowestScore {
+					lowest := score.Score
+					status.LowestScore = &lowest
+				}
+				scored, total = scored+1, total+score.Score
+			}
+		}
+		if live := member.Location; live != nil {
+			recorded, _ := time.Parse(time.RFC3339, live.RecordedAt)
+			member.Reporting = now.Sub(recorded) <= o.staleAfter
+			if area != nil {
+				inside := area.contains(live.Longitude, live.Latitude)
+				member.InsideGeofence = &inside
+				if !inside {
+					status.OutsideGeofence++
+				}
+			}
+		}
+		if member.Reporting {
+			status.Reporting++
+		} else {
+			status.Silent++
+		}
+		status.Levels[member.Level]++
+		status.MemberStatus = append(status.MemberStatus, member)
+	}
+	if scored > 0 {
+		average := float64(total) / float64(scored)
+		status.AverageScore = &average
+	}
+
+	alerts, err := o.store.alerts(ctx, group.GroupID)
+	if err != nil {
+		return status, err
+	}
+	for _, alert := range alerts {
+		if at, err := time.Parse(time.RFC3339, alert.At); err == nil && now.Sub(at) <= o.recentAlerts {
+			status.RecentAlerts++
+		}
+	}
+	return status, nil
+}
+
+type redisOperatorStore struct {
+	redis *redisClient
+}
+
+func (s redisOperatorStore) groups(ctx context.Context) (map[string]OperatorGroup, error) {
+	return redisHashJSON[OperatorGroup](ctx, s.redis, operatorGroupsKey)
+}
+
+func (s redisOperatorStore) putGroup(ctx context.Context, group OperatorGroup) error {
+	data, err := json.Marshal(group)
+	if err != nil {
+		return err
+	}
+	_, err = s.redis.Do(ctx, "HSET", operatorGroupsKey, group.GroupID, string(data))
+	return err
+}
+
+func (s redisOperatorStore) removeGroup(ctx context.Context, groupID string) (bool, error) {
+	reply, err := s.redis.Do(ctx, "HDEL", operatorGroupsKey, groupID)
+	n, _ := reply.(int64)
+	if err != nil || n != 1 {
+		return false, err
+	}
+	_, err = s.redis.Do(ctx, "DEL", operatorAlertsPrefix+groupID, operatorOutsidePrefix+groupID)
+	return true, err
+}
+
+func (s redisOperatorStore) addAlert(ctx context.Context, alert OperatorAlert) error {
+	data, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	key := operatorAlertsPrefix + alert.GroupID
+	if _, err := s.redis.Do(ctx, "LPUSH", key, string(data)); err != nil {
+		return err
+	}
+	_, err = s.redis.Do(ctx, "LTRIM", key, "0", strconv.Itoa(maxOperatorAlerts-1))
+	return err
+}
+
+func (s redisOperatorStore) alerts(ctx context.Context, groupID string) ([]OperatorAlert, error) {
+	reply, err := s.redis.Do(ctx, "LRANGE", operatorAlertsPrefix+groupID, "0", "-1")
+	if err != nil {
+		return nil, err
+	}
+	items, _ := reply.([]interface{})
+	alerts := make([]OperatorAlert, 0, len(items))
+	for _, item := range items {
+		raw, _ := item.([]byte)
+		var alert OperatorAlert
+		if err := json.Unmarshal(raw, &alert); err != nil {
+			return nil, err
+		}
+		alerts = append(alerts, alert)
+	}
+	return alerts, nil
+}
+
+func (s redisOperatorStore) setOutside(ctx context.Context, groupID, digitalID string, outside bool) (bool, error) {
+	command := "SREM"
+	if outside {
+		command = "SADD"
+	}
+	reply, err := s.redis.Do(ctx, command, operatorOutsidePrefix+groupID, digitalID)
+	n, _ := reply.(int64)
+	return n == 1, err
+}
+
+// memoryOperatorStore serves a single gateway instance
+type memoryOperatorStore struct {
+	mu       sync.Mutex
+	groupSet map[string]OperatorGroup
+	feeds    map[string][]OperatorAlert
+	outside  map[string]map[string]bool
+}
+
+func newMemoryOperatorStore() *memoryOperatorStore {
+	return &memoryOperatorStore{groupSet: map[string]OperatorGroup{}, feeds: map[string][]OperatorAlert{}, outside: map[string]map[string]bool{}}
+}
+
+func (s *memoryOperatorStore) groups(_ context.Context) (map[string]OperatorGroup, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	groups := make(map[string]OperatorGroup, len(s.groupSet))
+	for id, group := range s.groupSet {
+		groups[id] = group
+	}
+	return groups, nil
+}
+
+func (s *memoryOperatorStore) putGroup(_ context.Context, group OperatorGroup) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.groupSet[group.GroupID] = group
+	return nil
+}
+
+func (s *memoryOperatorStore) removeGroup(_ context.Context, groupID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.groupSet[groupID]
+	delete(s.groupSet, groupID)
+	delete(s.feeds, groupID)
+	delete(s.outside, groupID)
+	return ok, nil
+}
+
+func (s *memoryOperatorStore) addAlert(_ context.Context, alert OperatorAlert) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	feed := append([]OperatorAlert{alert}, s.feeds[alert.GroupID]...)
+	s.feeds[alert.GroupID] = feed[:min(len(feed), maxOperatorAlerts)]
+	return nil
+}
+
+func (s *memoryOperatorStore) alerts(_ context.Context, groupID string) ([]OperatorAlert, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.feeds[groupID]), nil
+}
+
+func (s *memoryOperatorStore) setOutside(_ context.Context, groupID, digitalID string, outside bool) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	members := s.outside[groupID]
+	if members == nil {
+		members = map[string]bool{}
+		s.outside[groupID] = members
+	}
+	changed := members[digitalID] != outside
+	if outside {
+		members[digitalID] = true
+	} else {
+		delete(members, digitalID)
+	}
+	return changed, nil
+}
+
+// Tour operator handlers
+
+func listOperatorGroups(c *gin.Context) {
+	all, err := operators.store.groups(c.Request.Context())
+	if err != nil {
+		respondServiceError(c, "Failed to list tour groups", err)
+		return
+	}
+	groups := []OperatorGroup{}
+	t := tenantFromContext(c.Request.Context())
+	for _, id := range sortedKeys(all) {
+		if t.owns(all[id].OwnerOrg) {
+			groups = append(groups, all[id])
+		}
+	}
+	respondData(c, http.StatusOK, groups)
+}
+
+func getOperatorGroup(c *gin.Context) {
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	group, err := operators.ownedGroup(c.Request.Context(), id)
+	if err != nil {
+		respondServiceError(c, "Failed to read tour group", err)
+		return
+	}
+	respondData(c, http.StatusOK, group)
+}
+
+// putOperatorGroup creates or replaces a group. The group belongs to the caller's organization, and
+// another organization's group cannot be replaced.
+func putOperatorGroup(c *gin.Context) {
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	var req OperatorGroupRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+	ctx := c.Request.Context()
+	all, err := operators.store.groups(ctx)
+	if err != nil {
+		respondServiceError(c, "Failed to read tour group", err)
+		return
+	}
+	org := callerOrg(ctx)
+	if existing, ok := all[id]; ok && existing.OwnerOrg != org {
+		respondError(c, http.StatusConflict, errCodeAlreadyExists, "A tour group with this ID belongs to another organization")
+		return
+	}
+	members := slices.Clone(req.Members)
+	if members == nil {
+		members = []string{}
+	}
+	sort.Strings(members)
+	group := OperatorGroup{GroupID: id, Name: req.Name, OwnerOrg: org, Members: members, Geofence: req.Geofence, UpdatedBy: req.Actor, UpdatedAt: time.Now().UTC().Format(time.RFC3339)}
+	setAuditTarget(c, id)
+	if err := operators.store.putGroup(ctx, group); err != nil {
+		respondServiceError(c, "Failed to save tour group", err)
+		return
+	}
+	respondData(c, http.StatusOK, group)
+}
+
+func deleteOperatorGroup(c *gin.Context) {
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	if _, err := operators.ownedGroup(ctx, id); err != nil {
+		respondServiceError(c, "Failed to delete tour group", err)
+		return
+	}
+	setAuditTarget(c, id)
+	if _, err := operators.store.removeGroup(ctx, id); err != nil {
+		respondServiceError(c, "Failed to delete tour group", err)
+		return
+	}
+	respondData(c, http.StatusOK, gin.H{"message": "Tour group deleted successfully", "groupID": id})
+}
+
+// issueOperatorGroupDIDs issues DIDs from a manifest, as POST /did/bulk does, and adds the members
+// whose DIDs were issued or already existed to the group
+func issueOperatorGroupDIDs(c *gin.Context) {
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	group, err := operators.ownedGroup(ctx, id)
+	if err != nil {
+		respondServiceError(c, "Failed to read tour group", err)
+		return
+	}
+	if c.Request.ContentLength > bulkIssuance.maxBytes {
+		respondError(c, http.StatusRequestEntityTooLarge, errCodePayloadTooLarge, fmt.Sprintf("The manifest must not exceed %d bytes", bulkIssuance.maxBytes))
+		return
+	}
+	body := http.MaxBytesReader(c.Writer, c.Request.Body, bulkIssuance.maxBytes)
+	manifest, err := parseDIDManifest(c.GetHeader("Content-Type"), body)
+	if err != nil {
+		var maxBytes *http.MaxBytesError
+		if errors.As(err, &maxBytes) {
+			respondError(c, http.StatusRequestEntityTooLarge, errCodePayloadTooLarge, fmt.Sprintf("The manifest must not exceed %d bytes", bulkIssuance.maxBytes))
+			return
+		}
+		respondValidationErrors(c, ValidationErrors{{Field: bulkManifestField, Message: err.Error()}})
+		return
+	}
+	joining := map[string]bool{}
+	for _, member := range manifest {
+		if !slices.Contains(group.Members, member.DigitalID) {
+			joining[member.DigitalID] = true
+		}
+	}
+	if len(group.Members)+len(joining) > maxOperatorGroupMembers {
+		respondValidationErrors(c, ValidationErrors{{Field: bulkManifestField, Message: fmt.Sprintf("would take the group past %d members", maxOperatorGroupMembers)}})
+		return
+	}
+
+	setAuditTarget(c, id)
+	report, err := ledger.IssueDIDs(ctx, manifest)
+	if err != nil {
+		respondServiceError(c, "Failed to issue DIDs", err)
+		return
+	}
+	log.Printf("🧑‍✈️ Tour group %s issuance: %d issued, %d existing, %d invalid, %d failed of %d", id, report.Issued, report.Existing, report.Invalid, report.Failed, report.Total)
+
+	// The group may have changed while the DIDs were issued
+	if group, err = operators.ownedGroup(ctx, id); err != nil {
+		respondServiceError(c, "Failed to read tour group", err)
+		return
+	}
+	for _, row := range report.Rows {
+		if (row.Status == bulkIssued || row.Status == bulkExisting) && !slices.Contains(group.Members, row.DigitalID) {
+			group.Members = append(group.Members, row.DigitalID)
+		}
+	}
+	sort.Strings(group.Members)
+	group.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	if err := operators.store.putGroup(ctx, group); err != nil {
+		respondServiceError(c, "Failed to save tour group", err)
+		return
+	}
+	respondData(c, http.StatusOK, OperatorGroupDIDs{Report: report, Group: group})
+}
+
+func getOperatorGroupStatus(c *gin.Context) {
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	group, err := operators.ownedGroup(ctx, id)
+	if err != nil {
+		respondServiceError(c, "Failed to read tour group", err)
+		return
+	}
+	status, err := operators.status(ctx, group, time.Now())
+	if err != nil {
+		respondServiceError(c, "Failed to read tour group status", err)
+		return
+	}
+	respondData(c, http.StatusOK, status)
+}
+
+func listOperatorGroupAlerts(c *gin.Context) {
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	if _, err := operators.ownedGroup(ctx, id); err != nil {
+		respondServiceError(c, "Failed to read tour group", err)
+		return
+	}
+	alerts, err := operators.store.alerts(ctx, id)
+	if err != nil {
+		respondServiceError(c, "Failed to list tour group alerts", err)
+		return
+	}
+	respondData(c, http.StatusOK, alerts)
+}
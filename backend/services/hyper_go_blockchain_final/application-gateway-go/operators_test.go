@@ -0,0 +1,112 @@
+package gateway
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+func TestOperatorGroupAlerts(t *testing.T) {
+	previousOperators, previousLocations := operators, locations
+	defer func() { operators, locations = previousOperators, previousLocations }()
+	operators = &operatorService{store: newMemoryOperatorStore(), staleAfter: 30 * time.Minute, recentAlerts: 24 * time.Hour}
+	locations = &locationService{store: newMemoryLocationStore(time.Hour)}
+	ctx := context.Background()
+
+	operators.store.putGroup(ctx, OperatorGroup{GroupID: "trek-42", Name: "Living root bridges trek", OwnerOrg: "Org1MSP", Members: []string{"did:sih:t1", "did:sih:t2"},
+		Geofence: &GeoJSONGeometry{Type: "Circle", Coordinates: []float64{91.68, 25.25}, Radius: 3000}})
+	operators.store.putGroup(ctx, OperatorGroup{GroupID: "shillong-city", Name: "Shillong city tour", OwnerOrg: "Org1MSP", Members: []string{"did:sih:t1"}})
+
+	at := time.Date(2025, 10, 5, 9, 0, 0, 0, time.UTC)
+	move := func(lat, lng float64, minutes int) {
+		operators.track(ctx, "did:sih:t1", LiveLocation{DigitalID: "did:sih:t1", Latitude: lat, Longitude: lng, RecordedAt: at.Add(time.Duration(minutes) * time.Minute).Format(time.RFC3339)})
+	}
+	count := func(groupID, kind string) int {
+		t.Helper()
+		alerts, err := operators.store.alerts(ctx, groupID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := 0
+		for _, alert := range alerts {
+			if alert.Type == kind {
+				n++
+			}
+		}
+		return n
+	}
+
+	move(25.25, 91.68, 0)
+	move(25.30, 91.68, 10)
+	move(25.31, 91.68, 20)
+	if n := count("trek-42", operatorAlertGeofence); n != 1 {
+		t.Errorf("expected one alert for leaving the area, got %d", n)
+	}
+	// Coming back re-arms the alert
+	move(25.25, 91.68, 30)
+	move(25.30, 91.68, 40)
+	if n := count("trek-42", operatorAlertGeofence); n != 2 {
+		t.Errorf("expected a second alert after returning and leaving again, got %d", n)
+	}
+
+	operators.anomalies(ctx, []AnomalyEvent{{AnomalyID: "ANOM-1", Type: anomalyInactivity, DigitalID: "did:sih:t1", Score: 0.6, At: at.Format(time.RFC3339), Detail: "has not moved for 2h"}})
+	if count("trek-42", operatorAlertAnomaly) != 1 || count("shillong-city", operatorAlertAnomaly) != 1 {
+		t.Error("expected the anomaly reported to both of the tourist's groups")
+	}
+
+	locations.store.update(ctx, LiveLocation{DigitalID: "did:sih:t2", Latitude: 25.26, Longitude: 91.67, RecordedAt: at.Format(time.RFC3339), ReceivedAt: time.Now().UTC().Format(time.RFC3339)})
+	operatorsFromEvent(ctx, &client.ChaincodeEvent{TransactionID: "tx-sos", EventName: "RaiseSOS", Payload: []byte(`{"alert_id":"SOS-1","digital_id":"did:sih:t2","raised_at":"2025-10-05T09:05:00Z"}`)})
+	alerts, _ := operators.store.alerts(ctx, "trek-42")
+	if sos := alerts[0]; sos.Type != operatorAlertSOS || sos.SourceID != "SOS-1" || sos.Severity != "critical" || sos.Latitude != 25.26 {
+		t.Errorf("expected the SOS at the member's live location first, got %+v", sos)
+	}
+	if count("shillong-city", operatorAlertSOS) != 0 {
+		t.Error("expected the SOS kept from groups the tourist is not in")
+	}
+}
+
+func TestOperatorGroupStatus(t *testing.T) {
+	previousLocations, previousSafety := locations, safety
+	defer func() { locations, safety = previousLocations, previousSafety }()
+	locations = &locationService{store: newMemoryLocationStore(time.Hour)}
+	safety = nil
+	o := &operatorService{store: newMemoryOperatorStore(), staleAfter: 30 * time.Minute, recentAlerts: 24 * time.Hour}
+	ctx := context.Background()
+
+	now := time.Date(2025, 10, 5, 12, 0, 0, 0, time.UTC)
+	group := OperatorGroup{GroupID: "trek-42", Name: "Living root bridges trek", Members: []string{"did:sih:t1", "did:sih:t2", "did:sih:t3"},
+		Geofence: &GeoJSONGeometry{Type: "Circle", Coordinates: []float64{91.68, 25.25}, Radius: 3000}}
+	locations.store.update(ctx, LiveLocation{DigitalID: "did:sih:t1", Latitude: 25.25, Longitude: 91.68, RecordedAt: now.Add(-5 * time.Minute).Format(time.RFC3339), ReceivedAt: time.Now().UTC().Format(time.RFC3339)})
+	locations.store.update(ctx, LiveLocation{DigitalID: "did:sih:t2", Latitude: 25.40, Longitude: 91.68, RecordedAt: now.Add(-2 * time.Hour).Format(time.RFC3339), ReceivedAt: time.Now().UTC().Format(time.RFC3339)})
+	o.store.addAlert(ctx, OperatorAlert{AlertID: "OPA-1", GroupID: "trek-42", Type: operatorAlertGeofence, At: now.Add(-time.Hour).Format(time.RFC3339)})
+	o.store.addAlert(ctx, OperatorAlert{AlertID: "OPA-2", GroupID: "trek-42", Type: operatorAlertSOS, At: now.Add(-48 * time.Hour).Format(time.RFC3339)})
+
+	status, err := o.status(ctx, group, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Members != 3 || status.Reporting != 1 || status.Silent != 2 || status.OutsideGeofence != 1 || status.RecentAlerts != 1 {
+		t.Errorf("unexpected status %+v", status)
+	}
+	if status.Levels[safetyUnknown] != 3 || status.LowestScore != nil {
+		t.Errorf("expected every member unscored without safety scoring, got %+v", status.Levels)
+	}
+	if inside := status.MemberStatus[0].InsideGeofence; inside == nil || !*inside || status.MemberStatus[2].InsideGeofence != nil {
+		t.Errorf("unexpected member status %+v", status.MemberStatus)
+	}
+}
+
+func TestOperatorGroupRequestValidation(t *testing.T) {
+	cases := map[string]OperatorGroupRequest{
+		"members[1]": {Name: "Trek", Members: []string{"did:sih:t1", "did:sih:t1"}, Actor: "meghalaya_tours"},
+		"members[0]": {Name: "Trek", Members: []string{"t1"}, Actor: "meghalaya_tours"},
+		"geofence":   {Name: "Trek", Geofence: &GeoJSONGeometry{Type: "Circle", Coordinates: []float64{91.68, 25.25}}, Actor: "meghalaya_tours"},
+	}
+	for field, req := range cases {
+		if errs := req.Validate(); len(errs) != 1 || errs[0].Field != field {
+			t.Errorf("expected %s rejected, got %v", field, errs)
+		}
+	}
+}
@@ -0,0 +1,787 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+const (
+	errCodeEscalationDisabled = "ESCALATION_DISABLED"
+
+	escalationCasePrefix   = "sih:notify:case:"
+	escalationDeadlinesKey = "sih:notify:deadlines"
+
+	controlRoomTarget = "control_room"
+
+	// Escalation states. A case stays escalating until it is acknowledged or its chain runs out.
+	escalationEscalating   = "escalating"
+	escalationAcknowledged = "acknowledged"
+	escalationCompleted    = "completed"
+
+	notifySkipped = "skipped"
+)
+
+var (
+	escalationEvents   = []string{"sos", "incident", slaEscalationEvent}
+	escalationChannels = []string{"push", "sms", "email", "ivr"}
+
+	errNoAddress = errors.New("recipient has no address on this channel")
+)
+
+// defaultNotificationPolicy is used unless NOTIFY_POLICY_FILE is set
+const defaultNotificationPolicy = `{"rules": [
+	{"event": "sos", "chain": [
+		{"target": "contact:1", "channels": ["sms", "ivr"], "wait": "3m"},
+		{"target": "contact:2", "channels": ["sms", "ivr"], "wait": "3m"},
+		{"target": "control_room", "channels": ["push", "sms", "email"]}
+	]},
+	{"event": "incident", "severities": ["critical"], "chain": [
+		{"target": "contact:1", "channels": ["sms", "email"], "wait": "10m"},
+		{"target": "control_room", "channels": ["push", "email"]}
+	]},
+	{"event": "incident", "severities": ["high"], "chain": [
+		{"target": "control_room", "channels": ["push", "email"]}
+	]},
+	{"event": "sla_breach", "chain": [
+		{"target": "control_room", "channels": ["push", "email"]}
+	]}
+]}`
+
+// NotificationPolicy routes events to escalation chains. The first matching rule wins, and events
+// no rule matches are not escalated.
+type NotificationPolicy struct {
+	Rules []NotificationRule `json:"rules"`
+}
+
+// NotificationRule matches an event, optionally only for some incident categories or severities
+type NotificationRule struct {
+	Event      string           `json:"event"`
+	Categories []string         `json:"categories,omitempty"`
+	Severities []string         `json:"severities,omitempty"`
+	Chain      []EscalationStep `json:"chain"`
+}
+
+// EscalationStep notifies one target on every listed channel, then waits for an acknowledgement
+// before moving on. Target is control_room or contact:N, the tourist's Nth emergency contact.
+type EscalationStep struct {
+	Target   string   `json:"target"`
+	Channels []string `json:"channels"`
+	Wait     string   `json:"wait,omitempty"`
+}
+
+func (s EscalationStep) wait() time.Duration {
+	d, _ := time.ParseDuration(s.Wait)
+	return d
+}
+
+// parseNotificationPolicy reads and checks a policy, so a mistake fails at startup rather than
+// in the middle of an emergency
+func parseNotificationPolicy(data []byte) (*NotificationPolicy, error) {
+	var policy NotificationPolicy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return nil, err
+	}
+	for i, rule := range policy.Rules {
+		if !slices.Contains(escalationEvents, rule.Event) {
+			return nil, fmt.Errorf("rule %d: event must be one of %s", i, strings.Join(escalationEvents, ", "))
+		}
+		for _, category := range rule.Categories {
+			if !slices.Contains(incidentCategories, category) {
+				return nil, fmt.Errorf("rule %d: unknown category %q", i, category)
+			}
+		}
+		for _, severity := range rule.Severities {
+			if !slices.Contains(incidentSeverities, severity) {
+				return nil, fmt.Errorf("rule %d: unknown severity %q", i, severity)
+			}
+		}
+		if len(rule.Chain) == 0 {
+			return nil, fmt.Errorf("rule %d: chain is empty", i)
+		}
+		for j, step := range rule.Chain {
+			if _, ok := contactIndex(step.Target); !ok && step.Target != controlRoomTarget {
+				return nil, fmt.Errorf("rule %d step %d: target must be control_room or contact:N", i, j)
+			}
+			// A breach concerns the response, not a tourist, so it has no contacts to notify
+			if rule.Event == slaEscalationEvent && step.Target != controlRoomTarget {
+				return nil, fmt.Errorf("rule %d step %d: %s chains can only notify control_room", i, j, slaEscalationEvent)
+			}
+			if len(step.Channels) == 0 {
+				return nil, fmt.Errorf("rule %d step %d: no channels", i, j)
+			}
+			for _, channel := range step.Channels {
+				if !slices.Contains(escalationChannels, channel) {
+					return nil, fmt.Errorf("rule %d step %d: channel must be one of %s", i, j, strings.Join(escalationChannels, ", "))
+				}
+			}
+			if j < len(rule.Chain)-1 && step.wait() <= 0 {
+				return nil, fmt.Errorf("rule %d step %d: every step but the last needs a positive wait", i, j)
+			}
+		}
+	}
+	return &policy, nil
+}
+
+// contactIndex returns N for a contact:N target, counting from zero
+func contactIndex(target string) (int, bool) {
+	n, err := strconv.Atoi(strings.TrimPrefix(target, "contact:"))
+	if !strings.HasPrefix(target, "contact:") || err != nil || n < 1 {
+		return 0, false
+	}
+	return n - 1, true
+}
+
+// match returns the chain for an event, or nil when no rule matches
+func (p *NotificationPolicy) match(event, category, severity string) []EscalationStep {
+	for _, rule := range p.Rules {
+		if rule.Event != event ||
+			(len(rule.Categories) > 0 && !slices.Contains(rule.Categories, category)) ||
+			(len(rule.Severities) > 0 && !slices.Contains(rule.Severities, severity)) {
+			continue
+		}
+		return rule.Chain
+	}
+	return nil
+}
+
+// EscalationDelivery is one notification sent at a step of the chain
+type EscalationDelivery struct {
+	Step int `json:"step"`
+	NotificationStatus
+	Attempts int    `json:"attempts"`
+	SentAt   string `json:"sent_at"`
+}
+
+// EscalationCase is one event working through its chain
+type EscalationCase struct {
+	NotificationID string               `json:"notification_id"`
+	Event          string               `json:"event"`
+	SubjectID      string               `json:"subject_id"`
+	DigitalID      string               `json:"digital_id,omitempty"`
+	Category       string               `json:"category,omitempty"`
+	Severity       string               `json:"severity,omitempty"`
+	Title          string               `json:"title"`
+	Message        string               `json:"message"`
+	MapURL         string               `json:"map_url,omitempty"`
+	Chain          []EscalationStep     `json:"chain"`
+	Step           int                  `json:"step"`
+	Status         string               `json:"status"`
+	NextAt         string               `json:"next_at,omitempty"`
+	AcknowledgedBy string               `json:"acknowledged_by,omitempty"`
+	AcknowledgedAt string               `json:"acknowledged_at,omitempty"`
+	Deliveries     []EscalationDelivery `json:"deliveries"`
+	CreatedAt      string               `json:"created_at"`
+	UpdatedAt      string               `json:"updated_at"`
+}
+
+// AcknowledgeEscalationRequest stops a case from escalating further
+type AcknowledgeEscalationRequest struct {
+	AcknowledgedBy string `json:"acknowledgedBy" binding:"required"`
+}
+
+func (r AcknowledgeEscalationRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.identifier("acknowledgedBy", r.AcknowledgedBy)
+	return v.errors
+}
+
+// EscalationNotification is the body posted to IVR_WEBHOOK_URL and, for SMS and email without a
+// provider, to the notification gateway
+type EscalationNotification struct {
+	NotificationID string `json:"notification_id"`
+	Event          string `json:"event"`
+	SubjectID      string `json:"subject_id"`
+	DigitalID      string `json:"digital_id,omitempty"`
+	Severity       string `json:"severity,omitempty"`
+	Title          string `json:"title"`
+	Message        string `json:"message"`
+	MapURL         string `json:"map_url,omitempty"`
+	Channel        string `json:"channel"`
+	To             string `json:"to"`
+	RecipientName  string `json:"recipient_name"`
+	RecipientKind  string `json:"recipient_kind"`
+}
+
+// escalationRecipient is a resolved step target with its addresses
+type escalationRecipient struct {
+	name  string
+	kind  string
+	phone string
+	email string
+	push  bool
+}
+
+// notifyChannel delivers a case's message to a recipient. It returns errNoAddress when the
+// recipient cannot be reached on the channel.
+type notifyChannel interface {
+	send(ctx context.Context, c *EscalationCase, r escalationRecipient) (messageID string, err error)
+}
+
+// escalationStore keeps cases with their next escalation time
+type escalationStore interface {
+	// create saves a new case, reporting false when one with the same ID already exists
+	create(ctx context.Context, c EscalationCase) (bool, error)
+	load(ctx context.Context, notificationID string) (*EscalationCase, error)
+	save(ctx context.Context, c EscalationCase) error
+	schedule(ctx context.Context, notificationID string, at time.Time) error
+	unschedule(ctx context.Context, notificationID string) error
+	// due removes and returns the cases whose next step is due
+	due(ctx context.Context, now time.Time) ([]string, error)
+}
+
+// notificationOrchestrator routes SOS alerts and incidents through escalation chains, retrying each
+// delivery with backoff and moving down the chain until someone acknowledges
+type notificationOrchestrator struct {
+	policy   *NotificationPolicy
+	store    escalationStore
+	channels map[string]notifyChannel
+	retries  int
+	backoff  time.Duration
+
+	controlRoom escalationRecipient
+	// mu serialises step changes within this instance; across instances the due claim does
+	mu sync.Mutex
+}
+
+var orchestrator *notificationOrchestrator
+
+// initOrchestrator runs after initSOS, initDocumentCache, initSMS, initEmail and initPush
+func initOrchestrator() {
+	if !getEnvBool("NOTIFY_ESCALATION_ENABLED", false) {
+		log.Println("📣 NOTIFY_ESCALATION_ENABLED not set, escalation chains disabled")
+		return
+	}
+	data := []byte(defaultNotificationPolicy)
+	if path := getEnv("NOTIFY_POLICY_FILE", ""); path != "" {
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			panic(fmt.Errorf("failed to read notification policy: %w", err))
+		}
+	}
+	policy, err := parseNotificationPolicy(data)
+	if err != nil {
+		panic(fmt.Errorf("invalid notification policy: %w", err))
+	}
+
+	ttl := getEnvDuration("NOTIFY_CASE_TTL", 72*time.Hour)
+	var store escalationStore = newMemoryEscalationStore()
+	backend := "memory"
+	if documentCache != nil {
+		store, backend = redisEscalationStore{documentCache, ttl}, "Redis"
+	}
+	orchestrator = &notificationOrchestrator{
+		policy: policy,
+		store:  store,
+		channels: map[string]notifyChannel{
+			"push":  pushChannel{},
+			"sms":   smsChannel{},
+			"email": emailChannel{},
+			"ivr":   ivrChannel{url: getEnv("IVR_WEBHOOK_URL", "")},
+		},
+		retries: getEnvInt("NOTIFY_RETRIES", 2),
+		backoff: getEnvDuration("NOTIFY_RETRY_BACKOFF", 2*time.Second),
+		controlRoom: escalationRecipient{
+			name:  "Control room",
+			kind:  controlRoomTarget,
+			phone: getEnv("NOTIFY_CONTROL_ROOM_PHONE", ""),
+			email: getEnv("NOTIFY_CONTROL_ROOM_EMAIL", ""),
+			push:  true,
+		},
+	}
+	log.Printf("📣 Escalating notifications through %d rules with cases in %s", len(policy.Rules), backend)
+}
+
+// escalationID is deterministic, so an event seen twice starts one case
+func escalationID(event, subjectID string) string {
+	return "NTF:" + event + ":" + subjectID
+}
+
+// handles reports whether a chain matches, so callers can leave the notifying to it
+func (o *notificationOrchestrator) handles(event, category, severity string) bool {
+	return o != nil && o.policy.match(event, category, severity) != nil
+}
+
+// start opens a case for an event and runs its first step. It returns the case ID, or "" when no
+// rule matches or the event already has a case.
+func (o *notificationOrchestrator) start(ctx context.Context, c EscalationCase) string {
+	c.Chain = o.policy.match(c.Event, c.Category, c.Severity)
+	if c.Chain == nil {
+		return ""
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	c.NotificationID = escalationID(c.Event, c.SubjectID)
+	c.Status, c.CreatedAt, c.UpdatedAt, c.Deliveries = escalationEscalating, now, now, []EscalationDelivery{}
+	created, err := o.store.create(ctx, c)
+	if err != nil {
+		log.Printf("📣 Failed to open escalation %s: %v", c.NotificationID, err)
+		return ""
+	}
+	if !created {
+		return ""
+	}
+	go o.runStep(context.WithoutCancel(ctx), c)
+	return c.NotificationID
+}
+
+// runStep notifies the current step's target on each of its channels, then schedules the next
+// step or completes the case
+func (o *notificationOrchestrator) runStep(ctx context.Context, c EscalationCase) {
+	step := c.Chain[c.Step]
+	recipient, err := o.recipient(ctx, &c, step.Target)
+	if err != nil {
+		log.Printf("📣 Failed to resolve %s for %s: %v", step.Target, c.NotificationID, err)
+	}
+	var deliveries []EscalationDelivery
+	for _, channel := range step.Channels {
+		deliveries = append(deliveries, o.deliver(ctx, &c, recipient, channel))
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	// An acknowledgement may have arrived while the deliveries were running
+	if current, err := o.store.load(ctx, c.NotificationID); err == nil && current != nil {
+		current.Step = c.Step
+		c = *current
+	}
+	c.Deliveries = append(c.Deliveries, deliveries...)
+	c.UpdatedAt, c.NextAt = time.Now().UTC().Format(time.RFC3339), ""
+	if c.Status == escalationEscalating {
+		if c.Step == len(c.Chain)-1 {
+			c.Status = escalationCompleted
+		} else {
+			next := time.Now().Add(step.wait()).UTC()
+			c.NextAt = next.Format(time.RFC3339)
+			if err := o.store.schedule(ctx, c.NotificationID, next); err != nil {
+				log.Printf("📣 Failed to schedule escalation %s: %v", c.NotificationID, err)
+			}
+		}
+	}
+	if err := o.store.save(ctx, c); err != nil {
+		log.Printf("📣 Failed to save escalation %s: %v", c.NotificationID, err)
+	}
+}
+
+// recipient resolves a step target. A missing contact resolves to a recipient without addresses,
+// so its deliveries are recorded as skipped and the chain moves on.
+func (o *notificationOrchestrator) recipient(ctx context.Context, c *EscalationCase, target string) (escalationRecipient, error) {
+	if target == controlRoomTarget {
+		return o.controlRoom, nil
+	}
+	i, _ := contactIndex(target)
+	r := escalationRecipient{name: target, kind: "emergency_contact"}
+	if emergencyContacts == nil || c.DigitalID == "" {
+		return r, nil
+	}
+	contacts, err := emergencyContacts.contacts(ctx, c.DigitalID)
+	if err != nil || i >= len(contacts) {
+		return r, err
+	}
+	r.name, r.phone, r.email = contacts[i].Name, contacts[i].Phone, contacts[i].Email
+	return r, nil
+}
+
+// deliver sends on one channel, retrying failures NOTIFY_RETRIES times with exponential backoff
+func (o *notificationOrchestrator) deliver(ctx context.Context, c *EscalationCase, r escalationRecipient, channel string) EscalationDelivery {
+	d := EscalationDelivery{
+		Step:               c.Step,
+		NotificationStatus: NotificationStatus{Recipient: r.name, Kind: r.kind, Channel: channel},
+		SentAt:             time.Now().UTC().Format(time.RFC3339),
+	}
+	for {
+		d.Attempts++
+		messageID, err := o.channels[channel].send(ctx, c, r)
+		switch {
+		case err == nil:
+			d.Status, d.MessageID, d.Error = notifyDelivered, messageID, ""
+			return d
+		case errors.Is(err, errNoAddress):
+			d.Status, d.Error = notifySkipped, err.Error()
+			return d
+		}
+		d.Status, d.Error = notifyFailed, err.Error()
+		if d.Attempts > o.retries {
+			log.Printf("📣 %s: failed to notify %s by %s: %v", c.NotificationID, r.name, channel, err)
+			return d
+		}
+		select {
+		case <-ctx.Done():
+			return d
+		case <-time.After(o.backoff << (d.Attempts - 1)):
+		}
+	}
+}
+
+// run moves cases whose wait ran out to their next step. Each is claimed by exactly one gateway
+// instance.
+func (o *notificationOrchestrator) run(ctx context.Context) {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := o.sweep(context.Background(), time.Now()); err != nil {
+				log.Printf("📣 Failed to sweep escalations: %v", err)
+			}
+		}
+	}
+}
+
+func (o *notificationOrchestrator) sweep(ctx context.Context, now time.Time) error {
+	due, err := o.store.due(ctx, now)
+	if err != nil {
+		return err
+	}
+	for _, id := range due {
+		c, err := o.store.load(ctx, id)
+		if err != nil {
+			return err
+		}
+		if c == nil || c.Status != escalationEscalating || c.Step >= len(c.Chain)-1 {
+			continue
+		}
+		c.Step++
+		log.Printf("📣 %s unacknowledged, escalating to %s", id, c.Chain[c.Step].Target)
+		o.runStep(ctx, *c)
+	}
+	return nil
+}
+
+// acknowledge stops a case from escalating. Acknowledging it again changes nothing.
+func (o *notificationOrchestrator) acknowledge(ctx context.Context, id string, req AcknowledgeEscalationRequest) (*EscalationCase, error) {
+	if err := validateMutation(id, req); err != nil {
+		return nil, err
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	c, err := o.store.load(ctx, id)
+	if err != nil || c == nil || c.Status == escalationAcknowledged {
+		return c, err
+	}
+	if err := o.store.unschedule(ctx, id); err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	c.Status, c.AcknowledgedBy, c.AcknowledgedAt, c.UpdatedAt, c.NextAt = escalationAcknowledged, req.AcknowledgedBy, now, now, ""
+	return c, o.store.save(ctx, *c)
+}
+
+// orchestrateFromEvent escalates new incidents. SOS alerts are started by RaiseSOS, which knows
+// the reported position.
+func orchestrateFromEvent(ctx context.Context, event *client.ChaincodeEvent) {
+	if orchestrator == nil || event.EventName != "CreateIncident" {
+		return
+	}
+	var incident IncidentDocument
+	if err := json.Unmarshal(event.Payload, &incident); err != nil {
+		return
+	}
+	c := EscalationCase{
+		Event:     "incident",
+		SubjectID: incident.IncidentID,
+		DigitalID: incident.SubjectID,
+		Category:  incident.Category,
+		Severity:  incident.Severity,
+		Title:     strings.TrimSpace(incident.Severity + " " + strings.ReplaceAll(incident.Category, "_", " ") + " incident"),
+		Message:   "Incident " + incident.IncidentID + " reported by " + incident.Reporter,
+	}
+	if incident.SubjectID != "" {
+		c.Message += " for tourist " + incident.SubjectID
+	}
+	if lat, lng := geohashCenter(incident.Geohash); lat != nil {
+		c.MapURL = fmt.Sprintf("https://maps.google.com/?q=%.6f,%.6f", lat, lng)
+	}
+	orchestrator.start(ctx, c)
+}
+
+// pushChannel alerts every registered official device; only the control room is reachable by push
+type pushChannel struct{}
+
+func (pushChannel) send(ctx context.Context, c *EscalationCase, r escalationRecipient) (string, error) {
+	if !r.push {
+		return "", errNoAddress
+	}
+	if pusher == nil {
+		return "", errors.New("FCM_PROJECT_ID is not set")
+	}
+	pusher.deliver(ctx, pushAlert{
+		key:   c.NotificationID + ":" + strconv.Itoa(c.Step),
+		title: c.Title,
+		body:  c.Message,
+		data:  map[string]string{"type": "escalation", "notification_id": c.NotificationID, "event": c.Event, "subject_id": c.SubjectID},
+	})
+	return "", nil
+}
+
+// smsChannel sends through SMS_PROVIDER, or the notification gateway without one
+type smsChannel struct{}
+
+func (smsChannel) send(ctx context.Context, c *EscalationCase, r escalationRecipient) (string, error) {
+	if r.phone == "" {
+		return "", errNoAddress
+	}
+	if smsGateway == nil {
+		return "", sosNotifier.post(ctx, sosNotifier.gatewayURL, escalationPayload(c, r, "sms", r.phone))
+	}
+	record, err := smsGateway.send(ctx, "escalation", r.phone, c.SubjectID, smsData{
+		RecipientName: r.name, DigitalID: c.DigitalID, Reference: c.SubjectID, MapURL: c.MapURL, Message: c.Message,
+	})
+	if err != nil {
+		return "", err
+	}
+	return record.MessageID, nil
+}
+
+// emailChannel sends through SMTP_HOST, or the notification gateway without it
+type emailChannel struct{}
+
+func (emailChannel) send(ctx context.Context, c *EscalationCase, r escalationRecipient) (string, error) {
+	if r.email == "" {
+		return "", errNoAddress
+	}
+	if emailer == nil {
+		return "", sosNotifier.post(ctx, sosNotifier.gatewayURL, escalationPayload(c, r, "email", r.email))
+	}
+	body := fmt.Sprintf("Dear %s,\n\n%s.\n", r.name, c.Message)
+	if c.MapURL != "" {
+		body += "Location: " + c.MapURL + "\n"
+	}
+	body += "\nReference: " + c.NotificationID + "\n"
+	return "", emailer.mailer.send(ctx, emailMessage{to: r.email, subject: c.Title, body: body})
+}
+
+// ivrChannel asks the IVR provider at url to place a voice call that reads out the message
+type ivrChannel struct {
+	url string
+}
+
+func (i ivrChannel) send(ctx context.Context, c *EscalationCase, r escalationRecipient) (string, error) {
+	if r.phone == "" {
+		return "", errNoAddress
+	}
+	if i.url == "" {
+		return "", errors.New("IVR_WEBHOOK_URL is not set")
+	}
+	return "", sosNotifier.post(ctx, i.url, escalationPayload(c, r, "ivr", r.phone))
+}
+
+func escalationPayload(c *EscalationCase, r escalationRecipient, channel, to string) EscalationNotification {
+	return EscalationNotification{
+		NotificationID: c.NotificationID, Event: c.Event, SubjectID: c.SubjectID, DigitalID: c.DigitalID, Severity: c.Severity,
+		Title: c.Title, Message: c.Message, MapURL: c.MapURL,
+		Channel: channel, To: to, RecipientName: r.name, RecipientKind: r.kind,
+	}
+}
+
+func escalationDisabled(c *gin.Context) bool {
+	if orchestrator == nil {
+		respondError(c, http.StatusServiceUnavailable, errCodeEscalationDisabled, "Escalation chains are not enabled")
+		return true
+	}
+	return false
+}
+
+func getEscalation(c *gin.Context) {
+	if escalationDisabled(c) {
+		return
+	}
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	escalation, err := orchestrator.store.load(c.Request.Context(), id)
+	if err != nil {
+		respondServiceError(c, "Failed to read escalation", err)
+		return
+	}
+	if escalation == nil {
+		respondError(c, http.StatusNotFound, errCodeNotFound, "Escalation not found")
+		return
+	}
+	respondData(c, http.StatusOK, escalation)
+}
+
+func acknowledgeEscalation(c *gin.Context) {
+	if escalationDisabled(c) {
+		return
+	}
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	var req AcknowledgeEscalationRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+	setAuditTarget(c, id)
+	escalation, err := orchestrator.acknowledge(c.Request.Context(), id, req)
+	if err != nil {
+		respondServiceError(c, "Failed to acknowledge escalation", err)
+		return
+	}
+	if escalation == nil {
+		respondError(c, http.StatusNotFound, errCodeNotFound, "Escalation not found")
+		return
+	}
+	respondData(c, http.StatusOK, escalation)
+}
+
+// redisEscalationStore keeps a key per case and the pending steps in a sorted set scored by time
+type redisEscalationStore struct {
+	redis *redisClient
+	ttl   time.Duration
+}
+
+func (s redisEscalationStore) create(ctx context.Context, c EscalationCase) (bool, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return false, err
+	}
+	_, err = s.redis.Do(ctx, "SET", escalationCasePrefix+c.NotificationID, string(data), "NX", "PX", strconv.FormatInt(s.ttl.Milliseconds(), 10))
+	if errors.Is(err, errRedisNil) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s redisEscalationStore) load(ctx context.Context, notificationID string) (*EscalationCase, error) {
+	data, err := s.redis.Get(ctx, escalationCasePrefix+notificationID)
+	if errors.Is(err, errRedisNil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var c EscalationCase
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (s redisEscalationStore) save(ctx context.Context, c EscalationCase) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return s.redis.Set(ctx, escalationCasePrefix+c.NotificationID, data, s.ttl)
+}
+
+func (s redisEscalationStore) schedule(ctx context.Context, notificationID string, at time.Time) error {
+	_, err := s.redis.Do(ctx, "ZADD", escalationDeadlinesKey, strconv.FormatInt(at.UnixMilli(), 10), notificationID)
+	return err
+}
+
+func (s redisEscalationStore) unschedule(ctx context.Context, notificationID string) error {
+	_, err := s.redis.Do(ctx, "ZREM", escalationDeadlinesKey, notificationID)
+	return err
+}
+
+// due claims each expired member with ZREM, so only one instance escalates it
+func (s redisEscalationStore) due(ctx context.Context, now time.Time) ([]string, error) {
+	reply, err := s.redis.Do(ctx, "ZRANGEBYSCORE", escalationDeadlinesKey, "-inf", strconv.FormatInt(now.UnixMilli(), 10), "LIMIT", "0", "100")
+	if err != nil {
+		return nil, err
+	}
+	members, _ := reply.([]interface{})
+	var due []string
+	for _, member := range members {
+		raw, _ := member.([]byte)
+		removed, err := s.redis.Do(ctx, "ZREM", escalationDeadlinesKey, string(raw))
+		if err != nil {
+			return due, err
+		}
+		if n, _ := removed.(int64); n == 1 {
+			due = append(due, string(raw))
+		}
+	}
+	return due, nil
+}
+
+// memoryEscalationStore serves a single gateway instance
+type memoryEscalationStore struct {
+	mu        sync.Mutex
+	cases     map[string]EscalationCase
+	deadlines map[string]time.Time
+}
+
+func newMemoryEscalationStore() *memoryEscalationStore {
+	return &memoryEscalationStore{cases: map[string]EscalationCase{}, deadlines: map[string]time.Time{}}
+}
+
+func (s *memoryEscalationStore) create(_ context.Context, c EscalationCase) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.cases[c.NotificationID]; ok {
+		return false, nil
+	}
+	s.cases[c.NotificationID] = c
+	return true, nil
+}
+
+func (s *memoryEscalationStore) load(_ context.Context, notificationID string) (*EscalationCase, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.cases[notificationID]
+	if !ok {
+		return nil, nil
+	}
+	c.Deliveries = slices.Clone(c.Deliveries)
+	return &c, nil
+}
+
+func (s *memoryEscalationStore) save(_ context.Context, c EscalationCase) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cases[c.NotificationID] = c
+	return nil
+}
+
+func (s *memoryEscalationStore) schedule(_ context.Context, notificationID string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deadlines[notificationID] = at
+	return nil
+}
+
+func (s *memoryEscalationStore) unschedule(_ context.Context, notificationID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.deadlines, notificationID)
+	return nil
+}
+
+func (s *memoryEscalationStore) due(_ context.Context, now time.Time) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var due []string
+	for id, at := range s.deadlines {
+		if !at.After(now) {
+			due = append(due, id)
+			delete(s.deadlines, id)
+		}
+	}
+	sort.Strings(due)
+	return due, nil
+}
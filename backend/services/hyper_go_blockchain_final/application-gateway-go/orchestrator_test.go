@@ -0,0 +1,120 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingChannel fails its first failures sends, then records every recipient it reaches
+type recordingChannel struct {
+	mu       sync.Mutex
+	failures int
+	sent     []string
+}
+
+func (r *recordingChannel) send(_ context.Context, _ *EscalationCase, to escalationRecipient) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if to.phone == "" {
+		return "", errNoAddress
+	}
+	if r.failures > 0 {
+		r.failures--
+		return "", errors.New("provider unavailable")
+	}
+	r.sent = append(r.sent, to.name)
+	return "", nil
+}
+
+func TestNotificationPolicy(t *testing.T) {
+	policy, err := parseNotificationPolicy([]byte(defaultNotificationPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chain := policy.match("incident", "theft", "critical"); len(chain) != 2 || chain[0].Target != "contact:1" {
+		t.Errorf("expected the critical incident chain, got %+v", chain)
+	}
+	if chain := policy.match("incident", "theft", "medium"); chain != nil {
+		t.Errorf("expected no chain for a medium incident, got %+v", chain)
+	}
+
+	for _, bad := range []string{
+		`{"rules": [{"event": "sos", "chain": []}]}`,
+		`{"rules": [{"event": "sos", "chain": [{"target": "contact:0", "channels": ["sms"]}]}]}`,
+		`{"rules": [{"event": "sos", "chain": [{"target": "control_room", "channels": ["fax"]}]}]}`,
+		`{"rules": [{"event": "sos", "chain": [{"target": "contact:1", "channels": ["sms"]}, {"target": "control_room", "channels": ["push"]}]}]}`,
+	} {
+		if _, err := parseNotificationPolicy([]byte(bad)); err == nil {
+			t.Errorf("expected %s to be rejected", bad)
+		}
+	}
+}
+
+func TestEscalationChain(t *testing.T) {
+	previous := emergencyContacts
+	emergencyContacts = fileContactDirectory{"did:sih:tourist_001": {{Name: "Asha", Phone: "+911234567890"}}}
+	defer func() { emergencyContacts = previous }()
+	ctx := context.Background()
+
+	policy, err := parseNotificationPolicy([]byte(`{"rules": [{"event": "sos", "chain": [
+		{"target": "contact:1", "channels": ["sms"], "wait": "1m"},
+		{"target": "contact:2", "channels": ["sms"], "wait": "1m"},
+		{"target": "control_room", "channels": ["sms"]}
+	]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sms := &recordingChannel{failures: 1}
+	store := newMemoryEscalationStore()
+	o := &notificationOrchestrator{
+		policy: policy, store: store, channels: map[string]notifyChannel{"sms": sms},
+		retries: 2, backoff: time.Millisecond,
+		controlRoom: escalationRecipient{name: "Control room", kind: controlRoomTarget, phone: "+91112"},
+	}
+
+	// The first step retries past the failure; starting the same SOS again is a duplicate
+	id := o.start(ctx, EscalationCase{Event: "sos", SubjectID: "SOS-1", DigitalID: "did:sih:tourist_001", Severity: "critical"})
+	if id != "NTF:sos:SOS-1" || o.start(ctx, EscalationCase{Event: "sos", SubjectID: "SOS-1"}) != "" {
+		t.Fatalf("expected one case for the SOS, got %q", id)
+	}
+	waitForStep := func(step int) *EscalationCase {
+		for range 100 {
+			if c, _ := store.load(ctx, id); c != nil && len(c.Deliveries) > step {
+				return c
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		t.Fatalf("step %d did not run", step)
+		return nil
+	}
+	c := waitForStep(0)
+	if d := c.Deliveries[0]; d.Status != notifyDelivered || d.Attempts != 2 || c.NextAt == "" {
+		t.Fatalf("expected delivery on the second attempt with a next step due, got %+v", c)
+	}
+
+	// Nobody acknowledges: the missing second contact is skipped, then the control room is called
+	later := time.Now().Add(2 * time.Minute)
+	o.sweep(ctx, later)
+	if c = waitForStep(1); c.Deliveries[1].Status != notifySkipped || c.Step != 1 {
+		t.Fatalf("expected the missing contact to be skipped, got %+v", c)
+	}
+	o.sweep(ctx, later.Add(2*time.Minute))
+	if c = waitForStep(2); c.Status != escalationCompleted || len(sms.sent) != 2 || sms.sent[1] != "Control room" {
+		t.Fatalf("expected the chain to end at the control room, got %+v after %v", c, sms.sent)
+	}
+
+	// An acknowledged case does not escalate
+	o.start(ctx, EscalationCase{Event: "sos", SubjectID: "SOS-2", DigitalID: "did:sih:tourist_001"})
+	id = "NTF:sos:SOS-2"
+	waitForStep(0)
+	if c, err := o.acknowledge(ctx, id, AcknowledgeEscalationRequest{AcknowledgedBy: "asha"}); err != nil || c.Status != escalationAcknowledged {
+		t.Fatalf("expected the case to be acknowledged, got %+v, %v", c, err)
+	}
+	o.sweep(ctx, later.Add(time.Hour))
+	if c, _ := store.load(ctx, id); c.Step != 0 || len(c.Deliveries) != 1 {
+		t.Errorf("expected no escalation after acknowledgement, got %+v", c)
+	}
+}
@@ -0,0 +1,446 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+// Inter-organization data sharing. A police district owning an incident can grant another district
+// or state body read access to it, and to its evidence, until a deadline. Grants are recorded on the
+// ledger; every gateway instance keeps them in memory so the tenancy checks on reads never wait on
+// the peers.
+
+const (
+	grantScopeIncident = "incident"
+	grantScopeEvidence = "evidence"
+
+	// maxGrantedEvidence matches the chaincode's limit on evidence listed in one grant
+	maxGrantedEvidence = 100
+)
+
+var (
+	orgGrantScopes     = []string{grantScopeIncident, grantScopeEvidence}
+	orgGrantDirections = []string{"granted", "received"}
+
+	// orgGrantMaxDuration bounds how far ahead a grant may expire
+	orgGrantMaxDuration = 30 * 24 * time.Hour
+)
+
+// AccessGrant lets GranteeOrg read one of GrantorOrg's incidents until ExpiresAt. EvidenceIDs limits
+// the evidence scope to the listed items; without them it covers all of the incident's evidence.
+type AccessGrant struct {
+	GrantID     string   `json:"grant_id"`
+	GrantorOrg  string   `json:"grantor_org"`
+	GranteeOrg  string   `json:"grantee_org"`
+	IncidentID  string   `json:"incident_id"`
+	Scopes      []string `json:"scopes"`
+	EvidenceIDs []string `json:"evidence_ids,omitempty"`
+	Reason      string   `json:"reason"`
+	GrantedBy   string   `json:"granted_by"`
+	GrantedAt   string   `json:"granted_at"`
+	ExpiresAt   string   `json:"expires_at"`
+	RevokedBy   string   `json:"revoked_by,omitempty"`
+	RevokedAt   string   `json:"revoked_at,omitempty"`
+	TxID        string   `json:"tx_id"`
+}
+
+// active reports whether the grant is neither revoked nor expired at now
+func (g AccessGrant) active(now time.Time) bool {
+	expires, err := time.Parse(time.RFC3339, g.ExpiresAt)
+	return g.RevokedAt == "" && err == nil && now.Before(expires)
+}
+
+// covers reports whether the grant extends to scope; evidenceID is checked against the listed
+// evidence of an evidence grant
+func (g AccessGrant) covers(scope, evidenceID string) bool {
+	if !slices.Contains(g.Scopes, scope) {
+		return false
+	}
+	return scope != grantScopeEvidence || len(g.EvidenceIDs) == 0 || slices.Contains(g.EvidenceIDs, evidenceID)
+}
+
+// CreateOrgGrantRequest grants GranteeOrg read access to one of the caller's incidents
+type CreateOrgGrantRequest struct {
+	GranteeOrg  string   `json:"granteeOrg" binding:"required"`
+	IncidentID  string   `json:"incidentID" binding:"required"`
+	Scopes      []string `json:"scopes" binding:"required"`
+	EvidenceIDs []string `json:"evidenceIDs"`
+	ExpiresAt   string   `json:"expiresAt" binding:"required"`
+	Reason      string   `json:"reason" binding:"required"`
+	Actor       string   `json:"actor" binding:"required"`
+}
+
+func (r CreateOrgGrantRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.identifier("granteeOrg", r.GranteeOrg)
+	v.identifier("incidentID", r.IncidentID)
+	if len(r.Scopes) == 0 {
+		v.add("scopes", "must list %s", strings.Join(orgGrantScopes, ", or "))
+	}
+	for i, scope := range r.Scopes {
+		field := fmt.Sprintf("scopes[%d]", i)
+		v.oneOf(field, scope, orgGrantScopes)
+		if slices.Contains(r.Scopes[:i], scope) {
+			v.add(field, "is listed more than once")
+		}
+	}
+	if len(r.EvidenceIDs) > 0 && !slices.Contains(r.Scopes, grantScopeEvidence) {
+		v.add("evidenceIDs", "may only be listed with the evidence scope")
+	}
+	if len(r.EvidenceIDs) > maxGrantedEvidence {
+		v.add("evidenceIDs", "must list at most %d items", maxGrantedEvidence)
+	}
+	for i, id := range r.EvidenceIDs {
+		field := fmt.Sprintf("evidenceIDs[%d]", i)
+		v.identifier(field, id)
+		if slices.Contains(r.EvidenceIDs[:i], id) {
+			v.add(field, "is listed more than once")
+		}
+	}
+	if expires, ok := v.rfc3339("expiresAt", r.ExpiresAt); ok {
+		now := time.Now()
+		switch {
+		case !expires.After(now):
+			v.add("expiresAt", "must be in the future")
+		case expires.After(now.Add(orgGrantMaxDuration)):
+			v.add("expiresAt", "must be within %s", orgGrantMaxDuration)
+		}
+	}
+	if reason := strings.TrimSpace(r.Reason); reason == "" || len(reason) > 500 {
+		v.add("reason", "must be 1 to 500 characters")
+	}
+	v.identifier("actor", r.Actor)
+	return v.errors
+}
+
+// OrgGrantListRequest filters the grants the caller's organization gave or received
+type OrgGrantListRequest struct {
+	Direction  string `form:"direction"`
+	IncidentID string `form:"incidentId"`
+	Active     bool   `form:"active"`
+}
+
+func (r OrgGrantListRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	if r.Direction != "" {
+		v.oneOf("direction", r.Direction, orgGrantDirections)
+	}
+	if r.IncidentID != "" {
+		v.identifier("incidentId", r.IncidentID)
+	}
+	return v.errors
+}
+
+func initOrgGrants() {
+	orgGrantMaxDuration = getEnvDuration("ORG_GRANT_MAX_DURATION", orgGrantMaxDuration)
+	if orgGrantMaxDuration <= 0 {
+		panic(fmt.Errorf("ORG_GRANT_MAX_DURATION must be positive"))
+	}
+	log.Printf("🤝 Organizations may grant each other read access for up to %s", orgGrantMaxDuration)
+}
+
+// orgGrantRegistry holds the default target's access grants, indexed by incident
+type orgGrantRegistry struct {
+	mu         sync.RWMutex
+	grants     map[string]AccessGrant
+	byIncident map[string][]string
+	syncedAt   time.Time
+}
+
+var orgGrants = newOrgGrantRegistry()
+
+func newOrgGrantRegistry() *orgGrantRegistry {
+	return &orgGrantRegistry{grants: map[string]AccessGrant{}, byIncident: map[string][]string{}}
+}
+
+func (r *orgGrantRegistry) put(grant AccessGrant) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.putLocked(grant)
+}
+
+func (r *orgGrantRegistry) putLocked(grant AccessGrant) {
+	existing, ok := r.grants[grant.GrantID]
+	if !ok {
+		r.byIncident[grant.IncidentID] = append(r.byIncident[grant.IncidentID], grant.GrantID)
+	} else if existing.RevokedAt != "" && grant.RevokedAt == "" {
+		// A revocation is final, even if the grant's creation is replayed after it
+		return
+	}
+	r.grants[grant.GrantID] = grant
+}
+
+func (r *orgGrantRegistry) replace(grants []AccessGrant) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.grants, r.byIncident, r.syncedAt = map[string]AccessGrant{}, map[string][]string{}, time.Now().UTC()
+	for _, grant := range grants {
+		r.putLocked(grant)
+	}
+}
+
+// allows reports whether org holds an active grant covering scope on the incident
+func (r *orgGrantRegistry) allows(org, scope, incidentID, evidenceID string, now time.Time) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, id := range r.byIncident[incidentID] {
+		grant := r.grants[id]
+		if grant.GranteeOrg == org && grant.active(now) && grant.covers(scope, evidenceID) {
+			return true
+		}
+	}
+	return false
+}
+
+// list returns the grants matching req that the tenant gave or received, newest first
+func (r *orgGrantRegistry) list(t tenant, org string, req OrgGrantListRequest, now time.Time) []AccessGrant {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	grants := []AccessGrant{}
+	for _, grant := range r.grants {
+		var visible bool
+		switch req.Direction {
+		case "granted":
+			visible = grant.GrantorOrg == org
+		case "received":
+			visible = grant.GranteeOrg == org
+		default:
+			visible = t.all || grant.GrantorOrg == org || grant.GranteeOrg == org
+		}
+		if visible && (req.IncidentID == "" || grant.IncidentID == req.IncidentID) && (!req.Active || grant.active(now)) {
+			grants = append(grants, grant)
+		}
+	}
+	slices.SortFunc(grants, func(a, b AccessGrant) int {
+		return cmp.Or(strings.Compare(b.GrantedAt, a.GrantedAt), strings.Compare(a.GrantID, b.GrantID))
+	})
+	return grants
+}
+
+func (r *orgGrantRegistry) run(ctx context.Context) {
+	interval := getEnvDuration("ORG_GRANT_SYNC_INTERVAL", 5*time.Minute)
+	retry := 10 * time.Second
+	for {
+		wait := interval
+		if err := r.sync(ctx); err != nil {
+			log.Printf("Failed to load access grants: %v", err)
+			wait = retry
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (r *orgGrantRegistry) sync(ctx context.Context) error {
+	ctx = withTarget(ctx, defaultTarget)
+	var grants []AccessGrant
+	bookmark := ""
+	for {
+		page, err := ledger.ExportDocuments(ctx, "access_grant", bookmark)
+		if err != nil {
+			return err
+		}
+		for _, doc := range page.Documents {
+			grant, err := decodeDocument[AccessGrant](doc, "access grant")
+			if err != nil {
+				log.Printf("Skipping access grant: %v", err)
+				continue
+			}
+			grants = append(grants, grant)
+		}
+		if page.Bookmark == "" {
+			break
+		}
+		bookmark = page.Bookmark
+	}
+	r.mu.RLock()
+	first := r.syncedAt.IsZero()
+	r.mu.RUnlock()
+	r.replace(grants)
+	if first {
+		log.Printf("🤝 Loaded %d access grants", len(grants))
+	}
+	return nil
+}
+
+// orgGrantsFromEvent applies grants and revocations from the default target, including ones made
+// through other gateway instances
+func orgGrantsFromEvent(ctx context.Context, event *client.ChaincodeEvent) {
+	if !isDefaultTarget(ctx) || (event.EventName != "GrantOrgAccess" && event.EventName != "RevokeOrgAccess") {
+		return
+	}
+	grant, err := decodeDocument[AccessGrant](event.Payload, "access grant event")
+	if err != nil {
+		log.Printf("Skipping access grant event: %v", err)
+		return
+	}
+	orgGrants.put(grant)
+}
+
+// reads reports whether the tenant may read a record of incidentID owned by ownerOrg, as its owner
+// or through an active grant covering scope
+func (t tenant) reads(ownerOrg, scope, incidentID, evidenceID string) bool {
+	return t.owns(ownerOrg) || orgGrants.allows(t.org, scope, incidentID, evidenceID, time.Now())
+}
+
+func newOrgGrantID(now time.Time) string {
+	suffix := make([]byte, 3)
+	rand.Read(suffix)
+	return fmt.Sprintf("GRANT-%s-%s", now.UTC().Format("20060102T150405Z"), hex.EncodeToString(suffix))
+}
+
+// GrantOrgAccess records a grant on one of the caller's own incidents; an organization holding a
+// grant cannot pass it on
+func (s ledgerService) GrantOrgAccess(ctx context.Context, grantID string, req CreateOrgGrantRequest) (*TransactionResult, error) {
+	if err := validateMutation(grantID, req); err != nil {
+		return nil, err
+	}
+	if req.GranteeOrg == callerOrg(ctx) {
+		return nil, ValidationErrors{{Field: "granteeOrg", Message: "must be another organization"}}
+	}
+	if _, err := s.ownedIncident(ctx, req.IncidentID); err != nil {
+		return nil, err
+	}
+	scopes, err := json.Marshal(req.Scopes)
+	if err != nil {
+		return nil, err
+	}
+	evidenceIDs := ""
+	if len(req.EvidenceIDs) > 0 {
+		listed, err := json.Marshal(req.EvidenceIDs)
+		if err != nil {
+			return nil, err
+		}
+		evidenceIDs = string(listed)
+	}
+	return submitTransaction(ctx, "GrantOrgAccess", grantID, req.GranteeOrg, req.IncidentID, string(scopes), evidenceIDs,
+		req.ExpiresAt, strings.TrimSpace(req.Reason), req.Actor)
+}
+
+// GetOrgGrant reads a grant the caller's organization gave or received
+func (s ledgerService) GetOrgGrant(ctx context.Context, grantID string) (AccessGrant, error) {
+	result, err := s.readDocument(ctx, "ReadAccessGrant", grantID)
+	if err != nil {
+		return AccessGrant{}, err
+	}
+	grant, err := decodeDocument[AccessGrant](result, "access grant")
+	if err != nil {
+		return AccessGrant{}, err
+	}
+	org := callerOrg(ctx)
+	if !tenantFromContext(ctx).all && grant.GrantorOrg != org && grant.GranteeOrg != org {
+		return AccessGrant{}, fmt.Errorf("the access grant %s does not exist", grantID)
+	}
+	return grant, nil
+}
+
+// RevokeOrgAccess ends a grant early; the chaincode only lets the grantor organization
+func (s ledgerService) RevokeOrgAccess(ctx context.Context, grantID string, req DeleteRequest) (*TransactionResult, error) {
+	if err := validateMutation(grantID, req); err != nil {
+		return nil, err
+	}
+	if _, err := s.GetOrgGrant(ctx, grantID); err != nil {
+		return nil, err
+	}
+	return submitAndInvalidate(ctx, grantID, "RevokeOrgAccess", grantID, req.Actor)
+}
+
+// ListOrgGrants lists the grants known to this instance that the caller's organization gave or
+// received
+func (ledgerService) ListOrgGrants(ctx context.Context, req OrgGrantListRequest) ([]AccessGrant, error) {
+	if errs := req.Validate(); len(errs) > 0 {
+		return nil, errs
+	}
+	return orgGrants.list(tenantFromContext(ctx), callerOrg(ctx), req, time.Now()), nil
+}
+
+func createOrgGrant(c *gin.Context) {
+	var req CreateOrgGrantRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+	grantID := newOrgGrantID(time.Now())
+	setAuditTarget(c, grantID)
+
+	result, err := ledger.GrantOrgAccess(c.Request.Context(), grantID, req)
+	if err != nil {
+		respondServiceError(c, "Failed to grant access", err)
+		return
+	}
+	respondCommitted(c, http.StatusCreated, gin.H{
+		"message":    "Access granted successfully",
+		"grantID":    grantID,
+		"granteeOrg": req.GranteeOrg,
+		"incidentID": req.IncidentID,
+		"expiresAt":  req.ExpiresAt,
+	}, result)
+}
+
+func listOrgGrants(c *gin.Context) {
+	var req OrgGrantListRequest
+	if !bindQuery(c, &req) {
+		return
+	}
+	grants, err := ledger.ListOrgGrants(c.Request.Context(), req)
+	if err != nil {
+		respondServiceError(c, "Failed to list access grants", err)
+		return
+	}
+	respondData(c, http.StatusOK, grants)
+}
+
+func getOrgGrant(c *gin.Context) {
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	grant, err := ledger.GetOrgGrant(c.Request.Context(), id)
+	if err != nil {
+		respondServiceError(c, "Failed to read access grant", err)
+		return
+	}
+	respondTagged(c, grant, grant.TxID)
+}
+
+func revokeOrgGrant(c *gin.Context) {
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	var req DeleteRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+
+	result, err := ledger.RevokeOrgAccess(c.Request.Context(), id, req)
+	if err != nil {
+		respondServiceError(c, "Failed to revoke access grant", err)
+		return
+	}
+	respondCommitted(c, http.StatusOK, gin.H{
+		"message": "Access grant revoked successfully",
+		"grantID": id,
+	}, result)
+}
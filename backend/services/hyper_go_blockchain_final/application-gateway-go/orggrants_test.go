@@ -0,0 +1,104 @@
+package gateway
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestOrgGrantReads(t *testing.T) {
+	tenancy = tenancyConfig{enabled: true, adminOrgs: map[string]bool{}, legacyOrg: "Org1MSP"}
+	previous := orgGrants
+	orgGrants = newOrgGrantRegistry()
+	defer func() { tenancy, orgGrants = tenancyConfig{}, previous }()
+
+	now := time.Now().UTC()
+	expires := now.Add(time.Hour).Format(time.RFC3339)
+	orgGrants.replace([]AccessGrant{
+		{GrantID: "GRANT-1", GrantorOrg: "Org1MSP", GranteeOrg: "Org2MSP", IncidentID: "INC-1", Scopes: []string{"incident", "evidence"}, EvidenceIDs: []string{"EV-1"}, ExpiresAt: expires, GrantedAt: "2025-09-20T10:00:00Z"},
+		{GrantID: "GRANT-2", GrantorOrg: "Org1MSP", GranteeOrg: "Org2MSP", IncidentID: "INC-2", Scopes: []string{"incident"}, ExpiresAt: now.Add(-time.Minute).Format(time.RFC3339), GrantedAt: "2025-09-20T11:00:00Z"},
+		{GrantID: "GRANT-3", GrantorOrg: "Org1MSP", GranteeOrg: "Org3MSP", IncidentID: "INC-1", Scopes: []string{"evidence"}, ExpiresAt: expires, GrantedAt: "2025-09-20T12:00:00Z"},
+	})
+
+	district := tenantFromContext(withSigner(context.Background(), &walletIdentity{Label: "shillong", MSPID: "Org2MSP"}))
+	state := tenant{org: "Org3MSP"}
+	for _, tc := range []struct {
+		tenant                        tenant
+		scope, incidentID, evidenceID string
+		want                          bool
+	}{
+		{district, grantScopeIncident, "INC-1", "", true},
+		{district, grantScopeEvidence, "INC-1", "EV-1", true},
+		// The evidence scope only covers the listed items
+		{district, grantScopeEvidence, "INC-1", "EV-2", false},
+		// An expired grant no longer applies
+		{district, grantScopeIncident, "INC-2", "", false},
+		// Without the incident scope, the grantee only reads the evidence
+		{state, grantScopeIncident, "INC-1", "", false},
+		{state, grantScopeEvidence, "INC-1", "EV-2", true},
+	} {
+		if got := tc.tenant.reads("Org1MSP", tc.scope, tc.incidentID, tc.evidenceID); got != tc.want {
+			t.Errorf("%s reading %s of %s/%s: expected %v", tc.tenant.org, tc.scope, tc.incidentID, tc.evidenceID, tc.want)
+		}
+	}
+
+	// A revocation ends the grant, even if its creation is replayed afterwards
+	revoked := orgGrants.grants["GRANT-1"]
+	revoked.RevokedAt = now.Format(time.RFC3339)
+	orgGrants.put(revoked)
+	revoked.RevokedAt = ""
+	orgGrants.put(revoked)
+	if district.reads("Org1MSP", grantScopeIncident, "INC-1", "") {
+		t.Error("expected the revoked grant to stop applying")
+	}
+
+	received := orgGrants.list(district, "Org2MSP", OrgGrantListRequest{Direction: "received"}, now)
+	if len(received) != 2 || received[0].GrantID != "GRANT-2" {
+		t.Errorf("expected both grants to Org2MSP, newest first, got %+v", received)
+	}
+	if active := orgGrants.list(district, "Org2MSP", OrgGrantListRequest{Active: true}, now); len(active) != 0 {
+		t.Errorf("expected no active grants to Org2MSP, got %+v", active)
+	}
+	if granted := orgGrants.list(tenant{org: "Org1MSP"}, "Org1MSP", OrgGrantListRequest{Direction: "granted", IncidentID: "INC-1"}, now); len(granted) != 2 {
+		t.Errorf("expected Org1MSP's two grants on INC-1, got %+v", granted)
+	}
+}
+
+func TestCreateOrgGrantValidation(t *testing.T) {
+	valid := CreateOrgGrantRequest{
+		GranteeOrg: "Org2MSP", IncidentID: "INC-1", Scopes: []string{"incident"},
+		ExpiresAt: time.Now().Add(24 * time.Hour).UTC().Format(time.RFC3339), Reason: "Joint investigation", Actor: "sp_kamrup",
+	}
+	if errs := valid.Validate(); len(errs) > 0 {
+		t.Fatalf("expected a valid grant, got %v", errs)
+	}
+	cases := []struct {
+		mutate func(*CreateOrgGrantRequest)
+		field  string
+	}{
+		{func(r *CreateOrgGrantRequest) { r.Scopes = nil }, "scopes"},
+		{func(r *CreateOrgGrantRequest) { r.Scopes = []string{"incident", "incident"} }, "scopes[1]"},
+		{func(r *CreateOrgGrantRequest) { r.Scopes = []string{"audit"} }, "scopes[0]"},
+		{func(r *CreateOrgGrantRequest) { r.EvidenceIDs = []string{"EV-1"} }, "evidenceIDs"},
+		{func(r *CreateOrgGrantRequest) { r.ExpiresAt = "2020-01-01T00:00:00Z" }, "expiresAt"},
+		{func(r *CreateOrgGrantRequest) {
+			r.ExpiresAt = time.Now().Add(orgGrantMaxDuration + time.Hour).UTC().Format(time.RFC3339)
+		}, "expiresAt"},
+		{func(r *CreateOrgGrantRequest) { r.Reason = "  " }, "reason"},
+	}
+	for _, tc := range cases {
+		req := valid
+		tc.mutate(&req)
+		if errs := req.Validate(); len(errs) != 1 || errs[0].Field != tc.field {
+			t.Errorf("expected %s rejected, got %v", tc.field, errs)
+		}
+	}
+
+	// An organization cannot grant access to itself
+	self := valid
+	self.GranteeOrg = mspID
+	_, err := ledger.GrantOrgAccess(context.Background(), "GRANT-1", self)
+	if errs, ok := err.(ValidationErrors); !ok || errs[0].Field != "granteeOrg" {
+		t.Errorf("expected a grant to the caller's own organization refused, got %v", err)
+	}
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// This file writes Apache Parquet files through parquet-go: flat schemas of optional columns,
+// gzip-compressed, with min/max statistics so readers can skip row groups.
+
+// parquetKind is a column's type. Values arrive as index rows, in PostgreSQL's text format, and
+// timestamps as milliseconds since the epoch.
+type parquetKind int
+
+const (
+	parquetString parquetKind = iota
+	parquetInt64
+	parquetDouble
+	parquetTimestamp
+)
+
+type parquetColumn struct {
+	name string
+	kind parquetKind
+}
+
+// metadata is the column's schema in parquet-go's tag format
+func (c parquetColumn) metadata() string {
+	switch c.kind {
+	case parquetString:
+		return fmt.Sprintf("name=%s, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL", c.name)
+	case parquetDouble:
+		return fmt.Sprintf("name=%s, type=DOUBLE, repetitiontype=OPTIONAL", c.name)
+	case parquetTimestamp:
+		return fmt.Sprintf("name=%s, type=INT64, convertedtype=TIMESTAMP_MILLIS, repetitiontype=OPTIONAL", c.name)
+	default:
+		return fmt.Sprintf("name=%s, type=INT64, repetitiontype=OPTIONAL", c.name)
+	}
+}
+
+// parquetWriter writes a file one row group at a time. Close writes the footer.
+type parquetWriter struct {
+	w       *writer.CSVWriter
+	columns []parquetColumn
+	rows    int64
+}
+
+func newParquetWriter(w io.Writer, columns []parquetColumn) (*parquetWriter, error) {
+	metadata := make([]string, len(columns))
+	for i, column := range columns {
+		metadata[i] = column.metadata()
+	}
+	pw, err := writer.NewCSVWriterFromWriter(metadata, w, 1)
+	if err != nil {
+		return nil, err
+	}
+	pw.CompressionType = parquet.CompressionCodec_GZIP
+	return &parquetWriter{w: pw, columns: columns}, nil
+}
+
+// WriteRowGroup writes rows whose cells follow the columns, NULL cells as nil
+func (p *parquetWriter) WriteRowGroup(rows []pgRow) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	for _, row := range rows {
+		cells := make([]*string, len(p.columns))
+		copy(cells, row)
+		if err := p.w.WriteString(cells); err != nil {
+			return err
+		}
+	}
+	p.rows += int64(len(rows))
+	return p.w.Flush(true)
+}
+
+// Close writes the file metadata and the footer. It does not close the underlying writer.
+func (p *parquetWriter) Close() error {
+	return p.w.WriteStop()
+}
@@ -0,0 +1,82 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strconv"
+	"testing"
+
+	"github.com/xitongsys/parquet-go-source/buffer"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/reader"
+)
+
+func TestParquetWriter(t *testing.T) {
+	columns := []parquetColumn{{"id", parquetString}, {"at", parquetTimestamp}, {"score", parquetDouble}}
+	text := func(s string) *string { return &s }
+	var rows []pgRow
+	for i := range 5 {
+		row := pgRow{text("INC-" + strconv.Itoa(i)), text(strconv.Itoa(1760000000000 + i)), nil}
+		if i%2 == 0 {
+			row[2] = text(strconv.FormatFloat(float64(i)/4, 'f', -1, 64))
+		}
+		rows = append(rows, row)
+	}
+
+	var file bytes.Buffer
+	w, err := newParquetWriter(&file, columns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteRowGroup(rows[:3]); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteRowGroup(rows[3:]); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	source, err := buffer.NewBufferFile(file.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := reader.NewParquetColumnReader(source, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.ReadStop()
+	meta := r.Footer
+	if meta.NumRows != 5 || len(meta.RowGroups) != 2 || len(meta.Schema) != 4 {
+		t.Fatalf("unexpected file metadata %v", meta)
+	}
+	// The reader capitalises names in the footer it hands back; the file keeps them as written
+	if at := meta.Schema[2]; r.SchemaHandler.Infos[2].ExName != "at" || at.GetType() != parquet.Type_INT64 || at.GetConvertedType() != parquet.ConvertedType_TIMESTAMP_MILLIS {
+		t.Errorf("expected the second column to be the at timestamp, got %v", at)
+	}
+
+	// Read the columns back, nulls included
+	ids, _, _, err := r.ReadColumnByIndex(0, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 5 || ids[0] != "INC-0" || ids[4] != "INC-4" {
+		t.Errorf("expected the IDs back in order, got %v", ids)
+	}
+	scores, _, levels, err := r.ReadColumnByIndex(2, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scores) != 5 || scores[0] != 0.0 || scores[1] != nil || levels[1] != 0 || scores[4] != 1.0 {
+		t.Errorf("expected scores with nulls back, got %v", scores)
+	}
+
+	stats := meta.RowGroups[0].Columns[1].MetaData.Statistics
+	if stats == nil {
+		t.Fatal("expected statistics for the first row group's timestamps")
+	}
+	if min, max := int64(binary.LittleEndian.Uint64(stats.MinValue)), int64(binary.LittleEndian.Uint64(stats.MaxValue)); min != 1760000000000 || max != 1760000000002 {
+		t.Errorf("expected the first row group's timestamps to range over its rows, got %d to %d", min, max)
+	}
+}
@@ -0,0 +1,264 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// parquetCheckpoint names the export's row in index_checkpoints: the block it has exported up
+	// to, and when it last ran
+	parquetCheckpoint = "parquet-export"
+	parquetMediaType  = "application/vnd.apache.parquet"
+)
+
+// lakeTable is an index table exported as Parquet, partitioned by the UTC date of its partition
+// column. Key is the table's unique key, for paging through a day.
+type lakeTable struct {
+	name      string
+	partition string
+	key       []string
+	// live excludes deleted rows, so that rewriting a partition drops erased data
+	live    string
+	columns []lakeColumn
+	// blocks is set when rows carry the block that last changed them, which finds the changed days
+	blocks bool
+}
+
+// lakeColumn is a column of the export and the expression selecting it
+type lakeColumn struct {
+	parquetColumn
+	expr string
+}
+
+func lakeText(name string) lakeColumn {
+	return lakeColumn{parquetColumn{name, parquetString}, name}
+}
+
+func lakeTime(name string) lakeColumn {
+	return lakeColumn{parquetColumn{name, parquetTimestamp}, fmt.Sprintf("(extract(epoch FROM %s) * 1000)::bigint", name)}
+}
+
+func lakeInt(name string) lakeColumn {
+	return lakeColumn{parquetColumn{name, parquetInt64}, name}
+}
+
+// lakeTables are the exported tables. Exact coordinates and evidence key references stay in the
+// index; incidents keep their geohash.
+var lakeTables = []lakeTable{
+	{name: "incidents", partition: "created_at", key: []string{"incident_id"}, live: "deleted_at IS NULL", blocks: true, columns: []lakeColumn{
+		lakeText("incident_id"), lakeText("incident_summary_hash"), lakeText("reporter"), lakeText("status"), lakeText("severity"),
+		lakeText("category"), lakeText("subject_id"), lakeText("geohash"), lakeText("owner_org"),
+		lakeTime("created_at"), lakeTime("acknowledged_at"), lakeTime("resolved_at"), lakeText("tx_id"), lakeInt("block_number"),
+	}},
+	{name: "evidence", partition: "created_at", key: []string{"evidence_id"}, live: "deleted_at IS NULL", blocks: true, columns: []lakeColumn{
+		lakeText("evidence_id"), lakeText("incident_id"), lakeText("evidence_hash"), lakeText("media_type"), lakeText("uploaded_by"),
+		lakeText("cid"), lakeText("owner_org"), lakeTime("created_at"), lakeText("tx_id"), lakeInt("block_number"),
+	}},
+	{name: "audits", partition: "timestamp", key: []string{"tx_id", "target_id", "action", "request_id"}, live: "TRUE", columns: []lakeColumn{
+		lakeText("tx_id"), lakeText("target_id"), lakeText("action"), lakeText("request_id"), lakeText("actor"), lakeText("audit_hash"),
+		lakeText("submitter"), lakeText("route"), lakeText("status"), lakeTime("timestamp"),
+	}},
+}
+
+// parquetExporter writes the off-chain index to object storage as Parquet, one file per table and
+// day, for analysis in Spark or DuckDB without touching the gateway or the peers
+type parquetExporter struct {
+	store    EvidenceStore
+	prefix   string
+	rowGroup int
+	// lookback is how far before the last run audits are re-exported, to catch entries indexed late
+	lookback time.Duration
+}
+
+var parquetExport *parquetExporter
+
+// initParquetExport enables the nightly export when PARQUET_EXPORT_ENABLED is set. It needs the
+// off-chain index and runs as the parquet-export job.
+func initParquetExport() {
+	if !getEnvBool("PARQUET_EXPORT_ENABLED", false) {
+		log.Println("🧮 PARQUET_EXPORT_ENABLED not set, the index is not exported as Parquet")
+		return
+	}
+	if offchain == nil {
+		panic(errors.New("PARQUET_EXPORT_ENABLED requires the off-chain index; set INDEX_DATABASE_URL"))
+	}
+	e := &parquetExporter{
+		prefix:   strings.Trim(getEnv("PARQUET_EXPORT_PREFIX", "lake"), "/"),
+		rowGroup: getEnvInt("PARQUET_EXPORT_ROW_GROUP", 50000),
+		lookback: getEnvDuration("PARQUET_EXPORT_LOOKBACK", 48*time.Hour),
+	}
+	if e.prefix == "" || e.rowGroup <= 0 || e.lookback < 0 {
+		panic(errors.New("PARQUET_EXPORT_PREFIX must not be empty, PARQUET_EXPORT_ROW_GROUP must be positive and PARQUET_EXPORT_LOOKBACK not negative"))
+	}
+	switch backend := getEnv("PARQUET_EXPORT_STORAGE", "local"); backend {
+	case "local":
+		dir := getEnv("PARQUET_EXPORT_DIR", "./parquet-export")
+		store, err := newLocalStore(dir)
+		if err != nil {
+			panic(fmt.Errorf("failed to initialise local Parquet store: %w", err))
+		}
+		e.store = store
+		log.Printf("🧮 Exporting the index as Parquet to %s/%s", dir, e.prefix)
+	case "s3", "minio":
+		store, err := newS3StoreFromEnv()
+		if err != nil {
+			panic(fmt.Errorf("failed to initialise S3 Parquet store: %w", err))
+		}
+		store.bucket = getEnv("PARQUET_EXPORT_S3_BUCKET", store.bucket)
+		e.store = store
+		log.Printf("🧮 Exporting the index as Parquet to s3://%s/%s at %s", store.bucket, e.prefix, store.endpoint)
+	default:
+		panic(fmt.Errorf("unknown PARQUET_EXPORT_STORAGE backend %q", backend))
+	}
+	parquetExport = e
+}
+
+// objectKey is a partition's file, in the Hive layout Spark and DuckDB discover partitions from
+func (e *parquetExporter) objectKey(table, day string) string {
+	return fmt.Sprintf("%s/%s/date=%s/part-00000.parquet", e.prefix, table, day)
+}
+
+// run rewrites the partitions of every day that ended before now and changed since the last run.
+// The first run exports every day.
+func (e *parquetExporter) run(ctx context.Context, now time.Time) error {
+	rows, err := offchain.db.Query(ctx, `SELECT block_number, `+utcColumn("updated_at")+` FROM index_checkpoints WHERE name = $1`, parquetCheckpoint)
+	if err != nil {
+		return err
+	}
+	since, lastRun := int64(-1), ""
+	if len(rows) > 0 {
+		since, lastRun = rows[0].Int(0), rows[0].String(1)
+	}
+	rows, err = offchain.db.Query(ctx, `SELECT GREATEST((SELECT max(block_number) FROM incidents), (SELECT max(block_number) FROM evidence))`)
+	if err != nil {
+		return err
+	}
+	watermark := rows[0].Int(0)
+
+	today := now.UTC().Format(time.DateOnly)
+	files, failed := 0, 0
+	for _, table := range lakeTables {
+		days, err := e.changedDays(ctx, table, since, lastRun, now)
+		if err != nil {
+			return fmt.Errorf("%s: %w", table.name, err)
+		}
+		for _, day := range days {
+			if day >= today {
+				continue
+			}
+			if err := e.exportDay(ctx, table, day); err != nil {
+				log.Printf("🧮 Failed to export %s for %s: %v", table.name, day, err)
+				failed++
+				continue
+			}
+			files++
+		}
+	}
+	if failed > 0 {
+		// The checkpoint stays put so the failed days are exported again on the next run
+		return fmt.Errorf("%d of %d partitions failed to export", failed, files+failed)
+	}
+	_, err = offchain.db.Exec(ctx, `INSERT INTO index_checkpoints (name, block_number, tx_id, updated_at) VALUES ($1, $2, '', $3)
+		ON CONFLICT (name) DO UPDATE SET block_number = EXCLUDED.block_number, updated_at = EXCLUDED.updated_at`,
+		parquetCheckpoint, watermark, now.UTC().Format(time.RFC3339))
+	if err != nil {
+		return err
+	}
+	log.Printf("🧮 Exported %d Parquet partitions, up to block %d", files, watermark)
+	return nil
+}
+
+// changedDays lists the days of a table to rewrite: yesterday, which has just ended, and any day
+// with rows changed since the last run. Audits never change, so those indexed since the last run
+// are found by a lookback instead.
+func (e *parquetExporter) changedDays(ctx context.Context, table lakeTable, since int64, lastRun string, now time.Time) ([]string, error) {
+	var f queryFilter
+	switch {
+	case table.blocks && since >= 0:
+		// Rows of the checkpointed block may have been indexed after it was read
+		f.add("block_number >= ?", since)
+	case !table.blocks && lastRun != "":
+		last, _ := time.Parse(time.RFC3339, lastRun)
+		f.add(table.partition+" >= ?", last.Add(-e.lookback).Format(time.RFC3339))
+	}
+	rows, err := offchain.db.Query(ctx, fmt.Sprintf(`SELECT DISTINCT to_char(%s AT TIME ZONE 'UTC', 'YYYY-MM-DD') FROM %s WHERE %s`,
+		table.partition, table.name, f.where()), f.args...)
+	if err != nil {
+		return nil, err
+	}
+	days := []string{now.UTC().AddDate(0, 0, -1).Format(time.DateOnly)}
+	for _, row := range rows {
+		days = append(days, row.String(0))
+	}
+	slices.Sort(days)
+	return slices.Compact(days), nil
+}
+
+// exportDay writes a day's rows as one file, in row groups of rowGroup rows, replacing the file of
+// an earlier run. A day left without rows has its file removed.
+func (e *parquetExporter) exportDay(ctx context.Context, table lakeTable, day string) error {
+	start, err := time.Parse(time.DateOnly, day)
+	if err != nil {
+		return err
+	}
+	exprs := make([]string, len(table.columns))
+	columns := make([]parquetColumn, len(table.columns))
+	for i, column := range table.columns {
+		exprs[i], columns[i] = column.expr, column.parquetColumn
+	}
+
+	var file bytes.Buffer
+	w, err := newParquetWriter(&file, columns)
+	if err != nil {
+		return err
+	}
+	var after []interface{}
+	for {
+		var f queryFilter
+		f.add(table.live)
+		f.add(table.partition+" >= ?", start.Format(time.RFC3339))
+		f.add(table.partition+" < ?", start.AddDate(0, 0, 1).Format(time.RFC3339))
+		if after != nil {
+			f.add(fmt.Sprintf("(%s) > (%s)", strings.Join(table.key, ", "), strings.Repeat("?, ", len(after)-1)+"?"), after...)
+		}
+		rows, err := offchain.db.Query(ctx, fmt.Sprintf(`SELECT %s FROM %s WHERE %s ORDER BY %s LIMIT %d`,
+			strings.Join(exprs, ", "), table.name, f.where(), strings.Join(table.key, ", "), e.rowGroup), f.args...)
+		if err != nil {
+			return err
+		}
+		if err := w.WriteRowGroup(rows); err != nil {
+			return err
+		}
+		if len(rows) < e.rowGroup {
+			break
+		}
+		// The key columns lead each table's columns
+		last := rows[len(rows)-1]
+		after = after[:0]
+		for i := range table.key {
+			after = append(after, last.String(i))
+		}
+	}
+
+	key := e.objectKey(table.name, day)
+	if w.rows == 0 {
+		return e.store.Delete(ctx, key)
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return e.store.Put(ctx, key, bytes.NewReader(file.Bytes()), int64(file.Len()), parquetMediaType)
+}
@@ -0,0 +1,223 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// A4 page geometry in PDF points
+const (
+	pdfPageWidth  = 595.0
+	pdfPageHeight = 842.0
+	pdfMargin     = 50.0
+)
+
+// helveticaWidths are the Helvetica advance widths for ASCII 32-126, in 1/1000 em
+var helveticaWidths = [95]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+// pdfDocument writes simple text documents using the standard Helvetica fonts, which every PDF
+// reader provides, so nothing has to be embedded
+type pdfDocument struct {
+	pages []*bytes.Buffer
+	y     float64
+}
+
+func newPDFDocument() *pdfDocument {
+	doc := &pdfDocument{}
+	doc.addPage()
+	return doc
+}
+
+func (d *pdfDocument) addPage() {
+	d.pages = append(d.pages, &bytes.Buffer{})
+	d.y = pdfPageHeight - pdfMargin
+}
+
+func (d *pdfDocument) page() *bytes.Buffer {
+	return d.pages[len(d.pages)-1]
+}
+
+// ensureSpace starts a new page when fewer than height points remain
+func (d *pdfDocument) ensureSpace(height float64) {
+	if d.y-height < pdfMargin {
+		d.addPage()
+	}
+}
+
+// textWidth measures s in points; bold glyphs are approximated as 5% wider
+func textWidth(s string, size float64, bold bool) float64 {
+	total := 0
+	for _, r := range pdfText(s) {
+		if r >= 32 && r <= 126 {
+			total += helveticaWidths[r-32]
+		} else {
+			total += 556
+		}
+	}
+	width := float64(total) * size / 1000
+	if bold {
+		width *= 1.05
+	}
+	return width
+}
+
+// wrapText breaks s into lines no wider than width
+func wrapText(s string, size, width float64, bold bool) []string {
+	var lines []string
+	for _, paragraph := range strings.Split(s, "\n") {
+		line := ""
+		for _, word := range strings.Fields(paragraph) {
+			candidate := word
+			if line != "" {
+				candidate = line + " " + word
+			}
+			if line != "" && textWidth(candidate, size, bold) > width {
+				lines = append(lines, line)
+				candidate = word
+			}
+			// Break words that cannot fit on a line by themselves, such as hashes
+			for textWidth(candidate, size, bold) > width && len(candidate) > 1 {
+				cut := len(candidate) - 1
+				for cut > 1 && textWidth(candidate[:cut], size, bold) > width {
+					cut--
+				}
+				lines = append(lines, candidate[:cut])
+				candidate = candidate[cut:]
+			}
+			line = candidate
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// text draws a single line at x on the current baseline
+func (d *pdfDocument) text(x float64, s string, size float64, bold bool) {
+	font := "F1"
+	if bold {
+		font = "F2"
+	}
+	fmt.Fprintf(d.page(), "BT /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, d.y, pdfEscape(pdfText(s)))
+}
+
+// Paragraph writes wrapped text, moving down the page
+func (d *pdfDocument) Paragraph(s string, size float64, bold bool) {
+	d.Indented(pdfMargin, s, size, bold)
+}
+
+// Indented writes wrapped text starting at x
+func (d *pdfDocument) Indented(x float64, s string, size float64, bold bool) {
+	leading := size * 1.35
+	for _, line := range wrapText(s, size, pdfPageWidth-pdfMargin-x, bold) {
+		d.ensureSpace(leading)
+		d.y -= leading
+		d.text(x, line, size, bold)
+	}
+}
+
+// Centered writes a single line centred on the page
+func (d *pdfDocument) Centered(s string, size float64, bold bool) {
+	leading := size * 1.35
+	d.ensureSpace(leading)
+	d.y -= leading
+	d.text((pdfPageWidth-textWidth(s, size, bold))/2, s, size, bold)
+}
+
+// Field writes a bold label with its value wrapped in a second column
+func (d *pdfDocument) Field(label, value string, size float64) {
+	const labelWidth = 150.0
+	leading := size * 1.35
+	lines := wrapText(value, size, pdfPageWidth-2*pdfMargin-labelWidth, false)
+	d.ensureSpace(leading * float64(min(len(lines), 3)))
+	for i, line := range lines {
+		d.ensureSpace(leading)
+		d.y -= leading
+		if i == 0 {
+			d.text(pdfMargin, label, size, true)
+		}
+		d.text(pdfMargin+labelWidth, line, size, false)
+	}
+}
+
+// Rule draws a horizontal line across the text area
+func (d *pdfDocument) Rule() {
+	d.ensureSpace(10)
+	d.y -= 6
+	fmt.Fprintf(d.page(), "0.5 w %.2f %.2f m %.2f %.2f l S\n", pdfMargin, d.y, pdfPageWidth-pdfMargin, d.y)
+	d.y -= 4
+}
+
+// Space moves down by the given number of points
+func (d *pdfDocument) Space(points float64) {
+	d.y -= points
+}
+
+// Bytes serialises the document, adding a "Page n of m" footer to every page
+func (d *pdfDocument) Bytes() []byte {
+	var out bytes.Buffer
+	var offsets []int
+	object := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	pageCount := len(d.pages)
+	// Objects 1-4 are the catalog, page tree and fonts; each page then takes two objects
+	kids := make([]string, pageCount)
+	for i := range kids {
+		kids[i] = fmt.Sprintf("%d 0 R", 5+2*i)
+	}
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), pageCount))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+
+	for i, page := range d.pages {
+		footer := fmt.Sprintf("Page %d of %d", i+1, pageCount)
+		content := page.String() + fmt.Sprintf("BT /F1 8.0 Tf %.2f %.2f Td (%s) Tj ET\n",
+			pdfPageWidth-pdfMargin-textWidth(footer, 8, false), pdfMargin/2, footer)
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			pdfPageWidth, pdfPageHeight, 6+2*i))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", len(content), content))
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return out.Bytes()
+}
+
+// pdfText maps s onto printable ASCII, since the standard fonts cannot show other scripts
+func pdfText(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '\t':
+			return ' '
+		case r < 32 || r > 126:
+			return '?'
+		}
+		return r
+	}, s)
+}
+
+func pdfEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "(", `\(`, ")", `\)`).Replace(s)
+}
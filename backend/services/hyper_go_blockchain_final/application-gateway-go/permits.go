@@ -0,0 +1,666 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	permitPrefix        = "permits/"
+	permitReviewKey     = "sih:permits:review"
+	maxPermitReviewList = 200
+	maxPermitPurpose    = 500
+
+	errCodePermitsDisabled = "PERMITS_DISABLED"
+	errCodePermitDuplicate = "PERMIT_DUPLICATE"
+	errCodePermitState     = "PERMIT_INVALID_STATE"
+)
+
+// Permit application statuses, and the status of a revoked permit on the ledger
+const (
+	permitPending  = "pending"
+	permitIssued   = "issued"
+	permitRejected = "rejected"
+	permitRevoked  = "revoked"
+)
+
+// Reasons a checkpoint verification fails. DID failures carry the DID verdict's reason after the
+// did_ prefix.
+const (
+	permitReasonNone        = "no_permit"
+	permitReasonRevoked     = "permit_revoked"
+	permitReasonNotYetValid = "permit_not_yet_valid"
+	permitReasonExpired     = "permit_expired"
+	permitReasonOtherHolder = "permit_for_other_tourist"
+	permitReasonOtherZone   = "permit_for_other_zone"
+)
+
+var (
+	errPermitDuplicate = errors.New("the tourist already has a pending application for the zone")
+	errPermitState     = errors.New("the permit application is not pending")
+)
+
+// PermitApplicationRequest asks for a permit to enter a restricted or trekking zone
+type PermitApplicationRequest struct {
+	DigitalID  string `json:"digitalID" binding:"required"`
+	ZoneID     string `json:"zoneID" binding:"required"`
+	Purpose    string `json:"purpose" binding:"required"`
+	ValidFrom  string `json:"validFrom" binding:"required"`
+	ValidUntil string `json:"validUntil" binding:"required"`
+}
+
+func (r PermitApplicationRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.digitalID("digitalID", r.DigitalID)
+	v.identifier("zoneID", r.ZoneID)
+	if len(r.Purpose) > maxPermitPurpose {
+		v.add("purpose", "must not exceed %d characters", maxPermitPurpose)
+	}
+	from, fromOK := v.rfc3339("validFrom", r.ValidFrom)
+	until, untilOK := v.rfc3339("validUntil", r.ValidUntil)
+	switch {
+	case !fromOK || !untilOK:
+	case !until.After(from):
+		v.add("validUntil", "must be after validFrom")
+	case !until.After(time.Now()):
+		v.add("validUntil", "must be in the future")
+	}
+	return v.errors
+}
+
+// PermitReviewRequest approves or rejects a pending permit application
+type PermitReviewRequest struct {
+	Reviewer string `json:"reviewer" binding:"required"`
+	Note     string `json:"note"`
+}
+
+func (r PermitReviewRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.identifier("reviewer", r.Reviewer)
+	if len(r.Note) > 1000 {
+		v.add("note", "must not exceed 1000 characters")
+	}
+	return v.errors
+}
+
+// RevokePermitRequest withdraws an issued permit
+type RevokePermitRequest struct {
+	Reason string `json:"reason" binding:"required"`
+	Actor  string `json:"actor" binding:"required"`
+}
+
+func (r RevokePermitRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	if len(r.Reason) > maxPermitPurpose {
+		v.add("reason", "must not exceed %d characters", maxPermitPurpose)
+	}
+	v.identifier("actor", r.Actor)
+	return v.errors
+}
+
+// PermitCheckRequest is what a checkpoint scans: the tourist, the zone they are entering and,
+// optionally, the permit they present. Without a permit ID any of the tourist's permits for the
+// zone will do. At defaults to now.
+type PermitCheckRequest struct {
+	DigitalID string `json:"digitalID" binding:"required"`
+	ZoneID    string `json:"zoneID" binding:"required"`
+	PermitID  string `json:"permitID"`
+	At        string `json:"at"`
+}
+
+func (r PermitCheckRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.digitalID("digitalID", r.DigitalID)
+	v.identifier("zoneID", r.ZoneID)
+	if r.PermitID != "" {
+		v.identifier("permitID", r.PermitID)
+	}
+	if r.At != "" {
+		v.rfc3339("at", r.At)
+	}
+	return v.errors
+}
+
+// PermitApplication is an application as the gateway keeps it. Once approved, the permit on the
+// ledger has the application's ID.
+type PermitApplication struct {
+	ApplicationID string `json:"application_id"`
+	DigitalID     string `json:"digital_id"`
+	ZoneID        string `json:"zone_id"`
+	Purpose       string `json:"purpose"`
+	ValidFrom     string `json:"valid_from"`
+	ValidUntil    string `json:"valid_until"`
+	Status        string `json:"status"`
+	ReviewedBy    string `json:"reviewed_by,omitempty"`
+	ReviewNote    string `json:"review_note,omitempty"`
+	ReviewedAt    string `json:"reviewed_at,omitempty"`
+	TxID          string `json:"tx_id,omitempty"`
+	SubmittedAt   string `json:"submitted_at"`
+	UpdatedAt     string `json:"updated_at"`
+}
+
+// PermitDocument is a permit as recorded on the ledger
+type PermitDocument struct {
+	DocType          string `json:"doc_type"`
+	PermitID         string `json:"permit_id"`
+	DigitalID        string `json:"digital_id"`
+	ZoneID           string `json:"zone_id"`
+	Purpose          string `json:"purpose"`
+	ValidFrom        string `json:"valid_from"`
+	ValidUntil       string `json:"valid_until"`
+	Status           string `json:"status"`
+	ApprovedBy       string `json:"approved_by"`
+	IssuedAt         string `json:"issued_at"`
+	RevokedBy        string `json:"revoked_by,omitempty"`
+	RevokedAt        string `json:"revoked_at,omitempty"`
+	RevocationReason string `json:"revocation_reason,omitempty"`
+	OwnerOrg         string `json:"owner_org,omitempty"`
+	TxID             string `json:"tx_id"`
+}
+
+// PermitVerification is a checkpoint's answer: Valid only when the DID is valid and a permit
+// covers the zone at the checked time. Permit is the permit checked, if the tourist has any for
+// the zone.
+type PermitVerification struct {
+	Valid     bool            `json:"valid"`
+	Reason    string          `json:"reason,omitempty"`
+	DigitalID string          `json:"digital_id"`
+	ZoneID    string          `json:"zone_id"`
+	Permit    *PermitDocument `json:"permit,omitempty"`
+	DID       DIDVerification `json:"did"`
+	CheckedAt string          `json:"checked_at"`
+}
+
+// permitService queues permit applications for review and issues approved ones on the ledger
+type permitService struct {
+	queue       reviewQueue
+	maxValidity time.Duration
+	// verifyDID and readZone check an application against the ledger; tests replace them
+	verifyDID func(ctx context.Context, digitalID string) (DIDVerification, error)
+	readZone  func(ctx context.Context, zoneID string) (Zone, error)
+	// mu serializes submissions and reviews, so a tourist has at most one pending application per zone
+	mu sync.Mutex
+}
+
+var permits *permitService
+
+func initPermits() {
+	if !getEnvBool("PERMITS_ENABLED", false) {
+		log.Println("🎫 PERMITS_ENABLED not set, zone permits disabled")
+		return
+	}
+	var queue reviewQueue = newMemoryReviewQueue()
+	backend := "memory"
+	if documentCache != nil {
+		queue, backend = redisReviewQueue{documentCache, permitReviewKey}, "Redis"
+	}
+	permits = &permitService{
+		queue:       queue,
+		maxValidity: getEnvDuration("PERMIT_MAX_VALIDITY", 30*24*time.Hour),
+		verifyDID:   ledger.VerifyDID,
+		readZone:    ledger.GetZone,
+	}
+	log.Printf("🎫 Zone permits enabled for up to %s, review queue in %s", permits.maxValidity, backend)
+}
+
+func permitKey(applicationID string) string {
+	return permitPrefix + applicationID + ".json"
+}
+
+// permitPendingKey holds the ID of a tourist's pending application for a zone
+func permitPendingKey(digitalID, zoneID string) string {
+	return permitPrefix + "pending/" + digitalID + "/" + zoneID
+}
+
+func newPermitID(now time.Time) string {
+	suffix := make([]byte, 3)
+	rand.Read(suffix)
+	return fmt.Sprintf("PRM-%s-%s", now.UTC().Format("20060102T150405Z"), hex.EncodeToString(suffix))
+}
+
+// load reads an application from the evidence store, or nil when there is none
+func (p *permitService) load(ctx context.Context, applicationID string) (*PermitApplication, error) {
+	body, err := evidenceStore.Get(ctx, permitKey(applicationID))
+	if errors.Is(err, errObjectNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	var app PermitApplication
+	if err := json.NewDecoder(body).Decode(&app); err != nil {
+		return nil, &malformedDocumentError{kind: "permit application", err: err}
+	}
+	return &app, nil
+}
+
+func (p *permitService) save(ctx context.Context, app *PermitApplication) error {
+	app.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	data, err := json.Marshal(app)
+	if err != nil {
+		return err
+	}
+	return evidenceStore.Put(ctx, permitKey(app.ApplicationID), bytes.NewReader(data), int64(len(data)), "application/json")
+}
+
+// pendingFor returns the tourist's pending application for the zone, if there is one
+func (p *permitService) pendingFor(ctx context.Context, digitalID, zoneID string) (*PermitApplication, error) {
+	body, err := evidenceStore.Get(ctx, permitPendingKey(digitalID, zoneID))
+	if errors.Is(err, errObjectNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	id, err := io.ReadAll(io.LimitReader(body, 256))
+	if err != nil {
+		return nil, err
+	}
+	app, err := p.load(ctx, string(id))
+	if err != nil || app == nil || app.Status != permitPending {
+		return nil, err
+	}
+	return app, nil
+}
+
+// submit queues an application for review once the DID is valid for the whole permit and the zone
+// exists
+func (p *permitService) submit(ctx context.Context, req PermitApplicationRequest) (*PermitApplication, error) {
+	if errs := req.Validate(); len(errs) > 0 {
+		return nil, errs
+	}
+	from, _ := time.Parse(time.RFC3339, req.ValidFrom)
+	until, _ := time.Parse(time.RFC3339, req.ValidUntil)
+	if until.Sub(from) > p.maxValidity {
+		return nil, ValidationErrors{{Field: "validUntil", Message: fmt.Sprintf("must be within %s of validFrom", p.maxValidity)}}
+	}
+	did, err := p.verifyDID(ctx, req.DigitalID)
+	if err != nil {
+		return nil, err
+	}
+	if !did.Valid {
+		return nil, ValidationErrors{{Field: "digitalID", Message: "is not a valid DID: " + did.Reason}}
+	}
+	if expires, err := time.Parse(time.RFC3339, did.ExpiresAt); err == nil && until.After(expires) {
+		return nil, ValidationErrors{{Field: "validUntil", Message: "must not be after the DID expires at " + did.ExpiresAt}}
+	}
+	if _, err := p.readZone(ctx, req.ZoneID); err != nil {
+		if translateFabricError(err).Code == errCodeNotFound {
+			return nil, ValidationErrors{{Field: "zoneID", Message: "is not a registered zone"}}
+		}
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	existing, err := p.pendingFor(ctx, req.DigitalID, req.ZoneID)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return existing, errPermitDuplicate
+	}
+	now := time.Now().UTC()
+	app := &PermitApplication{
+		ApplicationID: newPermitID(now), DigitalID: req.DigitalID, ZoneID: req.ZoneID, Purpose: req.Purpose,
+		ValidFrom: ledgerTimestamp(req.ValidFrom), ValidUntil: ledgerTimestamp(req.ValidUntil), Status: permitPending,
+		SubmittedAt: now.Format(time.RFC3339),
+	}
+	if err := p.save(ctx, app); err != nil {
+		return nil, err
+	}
+	pendingKey := permitPendingKey(app.DigitalID, app.ZoneID)
+	if err := evidenceStore.Put(ctx, pendingKey, bytes.NewReader([]byte(app.ApplicationID)), int64(len(app.ApplicationID)), "text/plain"); err != nil {
+		return nil, err
+	}
+	if err := p.queue.add(ctx, app.ApplicationID, now); err != nil {
+		return nil, err
+	}
+	log.Printf("🎫 Permit application %s queued for %s in %s", app.ApplicationID, app.DigitalID, app.ZoneID)
+	return app, nil
+}
+
+// review approves or rejects a pending application. Approving issues the permit on the ledger; if
+// that fails, the application stays pending and can be approved again.
+func (p *permitService) review(ctx context.Context, applicationID string, req PermitReviewRequest, approve bool) (*PermitApplication, *TransactionResult, error) {
+	if err := validateMutation(applicationID, req); err != nil {
+		return nil, nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	app, err := p.load(ctx, applicationID)
+	if err != nil || app == nil {
+		return nil, nil, err
+	}
+	if app.Status != permitPending {
+		return app, nil, errPermitState
+	}
+	var result *TransactionResult
+	if approve {
+		result, err = submitTransaction(ctx, "IssuePermit", app.ApplicationID, app.DigitalID, app.ZoneID, app.Purpose, app.ValidFrom, app.ValidUntil, req.Reviewer)
+		if err != nil && translateFabricError(err).Code != errCodeAlreadyExists {
+			return app, nil, err
+		}
+		app.Status = permitIssued
+		if result != nil {
+			app.TxID = result.TxID
+		}
+	} else {
+		app.Status = permitRejected
+	}
+	app.ReviewedBy, app.ReviewNote, app.ReviewedAt = req.Reviewer, req.Note, time.Now().UTC().Format(time.RFC3339)
+	if err := p.save(ctx, app); err != nil {
+		return nil, nil, err
+	}
+	if err := evidenceStore.Delete(ctx, permitPendingKey(app.DigitalID, app.ZoneID)); err != nil && !errors.Is(err, errObjectNotFound) {
+		return nil, nil, err
+	}
+	log.Printf("🎫 Permit application %s %s by %s", app.ApplicationID, app.Status, req.Reviewer)
+	return app, result, p.queue.remove(ctx, app.ApplicationID)
+}
+
+// pending lists the review queue, oldest first
+func (p *permitService) pending(ctx context.Context) ([]PermitApplication, error) {
+	ids, err := p.queue.list(ctx, maxPermitReviewList)
+	if err != nil {
+		return nil, err
+	}
+	apps := make([]PermitApplication, 0, len(ids))
+	for _, id := range ids {
+		app, err := p.load(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if app != nil {
+			apps = append(apps, *app)
+		}
+	}
+	return apps, nil
+}
+
+// permitReason reports why a permit does not admit the tourist to the zone at the given time, or
+// "" when it does
+func permitReason(permit PermitDocument, digitalID, zoneID string, at time.Time) string {
+	validFrom, _ := time.Parse(time.RFC3339, permit.ValidFrom)
+	validUntil, _ := time.Parse(time.RFC3339, permit.ValidUntil)
+	switch {
+	case permit.DigitalID != digitalID:
+		return permitReasonOtherHolder
+	case permit.ZoneID != zoneID:
+		return permitReasonOtherZone
+	case permit.Status == permitRevoked:
+		return permitReasonRevoked
+	case at.Before(validFrom):
+		return permitReasonNotYetValid
+	case !at.Before(validUntil):
+		return permitReasonExpired
+	}
+	return ""
+}
+
+// checkPermits decides a checkpoint verification from the DID's verdict and the candidate
+// permits. When none admits the tourist, the one reported is the candidate that lapses last.
+func checkPermits(verdict *PermitVerification, did DIDVerification, candidates []PermitDocument, at time.Time) {
+	verdict.DID = did
+	verdict.Reason = permitReasonNone
+	for i := range candidates {
+		permit := &candidates[i]
+		reason := permitReason(*permit, verdict.DigitalID, verdict.ZoneID, at)
+		if reason == "" {
+			verdict.Permit, verdict.Reason = permit, ""
+			break
+		}
+		if verdict.Permit == nil || permit.ValidUntil > verdict.Permit.ValidUntil {
+			verdict.Permit, verdict.Reason = permit, reason
+		}
+	}
+	if !did.Valid {
+		verdict.Reason = "did_" + did.Reason
+	}
+	verdict.Valid = verdict.Reason == ""
+}
+
+func (s ledgerService) GetPermit(ctx context.Context, permitID string) (PermitDocument, error) {
+	result, err := s.readDocument(ctx, "ReadPermit", permitID)
+	if err != nil {
+		return PermitDocument{}, err
+	}
+	return decodeDocument[PermitDocument](result, "permit")
+}
+
+// ListPermits returns a tourist's permits, latest issued first
+func (ledgerService) ListPermits(ctx context.Context, digitalID string) ([]PermitDocument, error) {
+	if errs := validateDocumentID("digitalId", digitalID); len(errs) > 0 {
+		return nil, errs
+	}
+	result, err := evaluateTransaction(ctx, "GetPermitsByDID", digitalID)
+	if err != nil {
+		return nil, err
+	}
+	list, err := decodeDocument[[]PermitDocument](result, "permit")
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].IssuedAt > list[j].IssuedAt })
+	return list, nil
+}
+
+// RevokePermit withdraws a permit; the chaincode only lets the issuing organization
+func (ledgerService) RevokePermit(ctx context.Context, permitID string, req RevokePermitRequest) (*TransactionResult, error) {
+	if err := validateMutation(permitID, req); err != nil {
+		return nil, err
+	}
+	return submitAndInvalidate(ctx, permitID, "RevokePermit", permitID, req.Reason, req.Actor)
+}
+
+// VerifyPermit checks a tourist's DID and zone permit in one call. Like VerifyDID it reads from the
+// peers rather than the cache, so a revocation takes effect at every checkpoint at once.
+func (ledgerService) VerifyPermit(ctx context.Context, req PermitCheckRequest) (PermitVerification, error) {
+	if errs := req.Validate(); len(errs) > 0 {
+		return PermitVerification{}, errs
+	}
+	at := time.Now().UTC()
+	if req.At != "" {
+		at, _ = time.Parse(time.RFC3339, req.At)
+	}
+	verdict := PermitVerification{DigitalID: req.DigitalID, ZoneID: req.ZoneID, CheckedAt: time.Now().UTC().Format(time.RFC3339)}
+	did, err := ledger.VerifyDID(ctx, req.DigitalID)
+	if err != nil {
+		return PermitVerification{}, err
+	}
+
+	var candidates []PermitDocument
+	if req.PermitID != "" {
+		result, err := evaluateTransaction(ctx, "ReadPermit", req.PermitID)
+		if err != nil && translateFabricError(err).Code != errCodeNotFound {
+			return PermitVerification{}, err
+		}
+		if err == nil {
+			permit, err := decodeDocument[PermitDocument](result, "permit")
+			if err != nil {
+				return PermitVerification{}, err
+			}
+			candidates = append(candidates, permit)
+		}
+	} else {
+		issued, err := ledger.ListPermits(ctx, req.DigitalID)
+		if err != nil {
+			return PermitVerification{}, err
+		}
+		for _, permit := range issued {
+			if permit.ZoneID == req.ZoneID {
+				candidates = append(candidates, permit)
+			}
+		}
+	}
+	checkPermits(&verdict, did, candidates, at)
+	return verdict, nil
+}
+
+func permitsDisabled(c *gin.Context) bool {
+	if permits == nil {
+		respondError(c, http.StatusServiceUnavailable, errCodePermitsDisabled, "Zone permits are disabled; set PERMITS_ENABLED")
+		return true
+	}
+	return false
+}
+
+// respondPermitApplication answers with the application: 202 while it waits for review, 201 once
+// the permit is on the ledger
+func respondPermitApplication(c *gin.Context, action string, app *PermitApplication, result *TransactionResult, err error) {
+	switch {
+	case errors.Is(err, errPermitDuplicate):
+		respondError(c, http.StatusConflict, errCodePermitDuplicate, fmt.Sprintf("%s already has pending application %s for %s", app.DigitalID, app.ApplicationID, app.ZoneID))
+	case errors.Is(err, errPermitState):
+		respondError(c, http.StatusConflict, errCodePermitState, fmt.Sprintf("Permit application %s is %s, not pending", app.ApplicationID, app.Status))
+	case err != nil:
+		respondServiceError(c, action, err)
+	case app == nil:
+		respondError(c, http.StatusNotFound, errCodeNotFound, "Permit application not found")
+	case app.Status == permitPending:
+		respondData(c, http.StatusAccepted, app)
+	case result != nil:
+		respondCommitted(c, http.StatusCreated, app, result)
+	default:
+		respondData(c, http.StatusOK, app)
+	}
+}
+
+func submitPermitApplication(c *gin.Context) {
+	if permitsDisabled(c) {
+		return
+	}
+	var req PermitApplicationRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+	app, err := permits.submit(c.Request.Context(), req)
+	if app != nil {
+		setAuditTarget(c, app.ApplicationID)
+	}
+	respondPermitApplication(c, "Failed to submit permit application", app, nil, err)
+}
+
+func getPermitApplication(c *gin.Context) {
+	if permitsDisabled(c) {
+		return
+	}
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	app, err := permits.load(c.Request.Context(), id)
+	respondPermitApplication(c, "Failed to read permit application", app, nil, err)
+}
+
+func listPermitReviewQueue(c *gin.Context) {
+	if permitsDisabled(c) {
+		return
+	}
+	apps, err := permits.pending(c.Request.Context())
+	if err != nil {
+		respondServiceError(c, "Failed to read the permit review queue", err)
+		return
+	}
+	respondData(c, http.StatusOK, apps)
+}
+
+func reviewPermitApplication(approve bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if permitsDisabled(c) {
+			return
+		}
+		id, ok := validPathID(c, "id")
+		if !ok {
+			return
+		}
+		var req PermitReviewRequest
+		if !bindRequest(c, &req) {
+			return
+		}
+		setAuditTarget(c, id)
+		app, result, err := permits.review(c.Request.Context(), id, req, approve)
+		respondPermitApplication(c, "Failed to review permit application", app, result, err)
+	}
+}
+
+func getPermit(c *gin.Context) {
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	permit, err := ledger.GetPermit(c.Request.Context(), id)
+	if err != nil {
+		respondServiceError(c, "Failed to read permit", err)
+		return
+	}
+	respondTagged(c, permit, permit.TxID)
+}
+
+func listPermits(c *gin.Context) {
+	id, ok := validPathID(c, "digitalId")
+	if !ok {
+		return
+	}
+	list, err := ledger.ListPermits(c.Request.Context(), id)
+	if err != nil {
+		respondServiceError(c, "Failed to list permits", err)
+		return
+	}
+	respondData(c, http.StatusOK, list)
+}
+
+func revokePermit(c *gin.Context) {
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	var req RevokePermitRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+	result, err := ledger.RevokePermit(c.Request.Context(), id, req)
+	if err != nil {
+		respondServiceError(c, "Failed to revoke permit", err)
+		return
+	}
+	respondCommitted(c, http.StatusOK, gin.H{
+		"message":  "Permit revoked successfully",
+		"permitID": id,
+	}, result)
+}
+
+// verifyPermit is the checkpoint call: is this tourist, with a valid DID, allowed into this zone now
+func verifyPermit(c *gin.Context) {
+	var req PermitCheckRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+	verdict, err := ledger.VerifyPermit(c.Request.Context(), req)
+	if err != nil {
+		respondServiceError(c, "Failed to verify permit", err)
+		return
+	}
+	respondData(c, http.StatusOK, verdict)
+}
@@ -0,0 +1,153 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestPermitApplicationReview(t *testing.T) {
+	store, err := newLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	previousStore := evidenceStore
+	evidenceStore = store
+	defer func() { evidenceStore = previousStore }()
+
+	now := time.Now().UTC()
+	didExpires := now.Add(20 * 24 * time.Hour).Format(time.RFC3339)
+	p := &permitService{
+		queue:       newMemoryReviewQueue(),
+		maxValidity: 14 * 24 * time.Hour,
+		verifyDID: func(_ context.Context, id string) (DIDVerification, error) {
+			if id == "did:sih:expired" {
+				return DIDVerification{DigitalID: id, Exists: true, Expired: true, Reason: "expired"}, nil
+			}
+			return DIDVerification{DigitalID: id, Valid: true, Exists: true, ExpiresAt: didExpires}, nil
+		},
+		readZone: func(_ context.Context, id string) (Zone, error) {
+			if id != "nokrek-trail" {
+				return Zone{}, fmt.Errorf("the zone %s does not exist", id)
+			}
+			return Zone{ZoneID: id}, nil
+		},
+	}
+	ctx := context.Background()
+	days := func(n int) string { return now.Add(time.Duration(n) * 24 * time.Hour).Format(time.RFC3339) }
+	req := PermitApplicationRequest{DigitalID: "did:sih:tourist_001", ZoneID: "nokrek-trail", Purpose: "Guided trek", ValidFrom: days(1), ValidUntil: days(4)}
+
+	app, err := p.submit(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if app.Status != permitPending {
+		t.Errorf("unexpected application %+v", app)
+	}
+	if _, err := p.submit(ctx, req); !errors.Is(err, errPermitDuplicate) {
+		t.Errorf("expected a second pending application refused, got %v", err)
+	}
+	if queued, _ := p.pending(ctx); len(queued) != 1 {
+		t.Errorf("expected one application awaiting review, got %+v", queued)
+	}
+
+	for field, bad := range map[string]PermitApplicationRequest{
+		"digitalID":  {DigitalID: "did:sih:expired", ZoneID: "nokrek-trail", Purpose: "Trek", ValidFrom: days(1), ValidUntil: days(2)},
+		"zoneID":     {DigitalID: "did:sih:tourist_002", ZoneID: "unknown", Purpose: "Trek", ValidFrom: days(1), ValidUntil: days(2)},
+		"validUntil": {DigitalID: "did:sih:tourist_002", ZoneID: "nokrek-trail", Purpose: "Trek", ValidFrom: days(10), ValidUntil: days(21)},
+	} {
+		var validationErrs ValidationErrors
+		if _, err := p.submit(ctx, bad); !errors.As(err, &validationErrs) || validationErrs[0].Field != field {
+			t.Errorf("expected %s refused, got %v", field, err)
+		}
+	}
+	long := req
+	long.DigitalID, long.ValidUntil = "did:sih:tourist_003", days(16)
+	if _, err := p.submit(ctx, long); err == nil {
+		t.Error("expected a permit longer than the maximum validity refused")
+	}
+
+	rejected, _, err := p.review(ctx, app.ApplicationID, PermitReviewRequest{Reviewer: "dfo_garo_hills", Note: "Trail closed for the monsoon"}, false)
+	if err != nil || rejected.Status != permitRejected || rejected.ReviewedBy != "dfo_garo_hills" {
+		t.Fatalf("expected the application rejected, got %+v %v", rejected, err)
+	}
+	if _, _, err := p.review(ctx, app.ApplicationID, PermitReviewRequest{Reviewer: "dfo_garo_hills"}, true); !errors.Is(err, errPermitState) {
+		t.Errorf("expected a rejected application not reviewed again, got %v", err)
+	}
+	if queued, _ := p.pending(ctx); len(queued) != 0 {
+		t.Errorf("expected the review queue empty, got %+v", queued)
+	}
+	// Once reviewed, the tourist may apply again
+	if _, err := p.submit(ctx, req); err != nil {
+		t.Errorf("expected a new application accepted, got %v", err)
+	}
+}
+
+func TestCheckPermits(t *testing.T) {
+	at := time.Date(2025, 10, 5, 9, 0, 0, 0, time.UTC)
+	valid := DIDVerification{DigitalID: "did:sih:tourist_001", Valid: true, Exists: true}
+	permit := func(id, from, until, status string) PermitDocument {
+		return PermitDocument{PermitID: id, DigitalID: "did:sih:tourist_001", ZoneID: "nokrek-trail", ValidFrom: from, ValidUntil: until, Status: status}
+	}
+	lapsed := permit("PRM-1", "2025-09-01T00:00:00Z", "2025-09-05T00:00:00Z", "active")
+	current := permit("PRM-2", "2025-10-04T00:00:00Z", "2025-10-06T00:00:00Z", "active")
+	revoked := permit("PRM-3", "2025-10-04T00:00:00Z", "2025-10-07T00:00:00Z", permitRevoked)
+	upcoming := permit("PRM-4", "2025-10-05T10:00:00Z", "2025-10-08T00:00:00Z", "active")
+	elsewhere := current
+	elsewhere.ZoneID = "balpakram"
+
+	cases := []struct {
+		name       string
+		did        DIDVerification
+		candidates []PermitDocument
+		reason     string
+		permitID   string
+	}{
+		{"current permit", valid, []PermitDocument{lapsed, current, revoked}, "", "PRM-2"},
+		{"no permits", valid, nil, permitReasonNone, ""},
+		{"only lapsed", valid, []PermitDocument{lapsed}, permitReasonExpired, "PRM-1"},
+		{"latest is revoked", valid, []PermitDocument{lapsed, revoked}, permitReasonRevoked, "PRM-3"},
+		{"not yet valid", valid, []PermitDocument{upcoming}, permitReasonNotYetValid, "PRM-4"},
+		{"another zone's permit", valid, []PermitDocument{elsewhere}, permitReasonOtherZone, "PRM-2"},
+		{"expired DID", DIDVerification{DigitalID: "did:sih:tourist_001", Exists: true, Expired: true, Reason: "expired"}, []PermitDocument{current}, "did_expired", "PRM-2"},
+	}
+	for _, tc := range cases {
+		verdict := PermitVerification{DigitalID: "did:sih:tourist_001", ZoneID: "nokrek-trail"}
+		checkPermits(&verdict, tc.did, tc.candidates, at)
+		if verdict.Reason != tc.reason || verdict.Valid != (tc.reason == "") {
+			t.Errorf("%s: expected reason %q, got %+v", tc.name, tc.reason, verdict)
+		}
+		reported := ""
+		if verdict.Permit != nil {
+			reported = verdict.Permit.PermitID
+		}
+		if reported != tc.permitID {
+			t.Errorf("%s: expected permit %q reported, got %q", tc.name, tc.permitID, reported)
+		}
+	}
+}
+
+func TestPermitRequestValidation(t *testing.T) {
+	future := time.Now().Add(24 * time.Hour).Format(time.RFC3339)
+	later := time.Now().Add(48 * time.Hour).Format(time.RFC3339)
+	cases := []struct {
+		req   PermitApplicationRequest
+		field string
+	}{
+		{PermitApplicationRequest{DigitalID: "tourist_001", ZoneID: "nokrek-trail", Purpose: "Trek", ValidFrom: future, ValidUntil: later}, "digitalID"},
+		{PermitApplicationRequest{DigitalID: "did:sih:tourist_001", ZoneID: "nokrek trail", Purpose: "Trek", ValidFrom: future, ValidUntil: later}, "zoneID"},
+		{PermitApplicationRequest{DigitalID: "did:sih:tourist_001", ZoneID: "nokrek-trail", Purpose: "Trek", ValidFrom: later, ValidUntil: future}, "validUntil"},
+		{PermitApplicationRequest{DigitalID: "did:sih:tourist_001", ZoneID: "nokrek-trail", Purpose: "Trek", ValidFrom: "2025-01-01T00:00:00Z", ValidUntil: "2025-01-02T00:00:00Z"}, "validUntil"},
+		{PermitApplicationRequest{DigitalID: "did:sih:tourist_001", ZoneID: "nokrek-trail", Purpose: "Trek", ValidFrom: "tomorrow", ValidUntil: later}, "validFrom"},
+	}
+	for _, tc := range cases {
+		if errs := tc.req.Validate(); len(errs) != 1 || errs[0].Field != tc.field {
+			t.Errorf("expected %s rejected, got %v", tc.field, errs)
+		}
+	}
+	if errs := (PermitCheckRequest{DigitalID: "did:sih:tourist_001", ZoneID: "nokrek-trail", At: "now"}).Validate(); len(errs) != 1 || errs[0].Field != "at" {
+		t.Errorf("expected at rejected, got %v", errs)
+	}
+}
@@ -0,0 +1,202 @@
+package gateway
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgproto3"
+)
+
+var pgPlaceholder = regexp.MustCompile(`\$\d+`)
+
+// fakePGServer requires MD5 authentication and answers every SELECT with one row of the
+// parameters it was bound with, every other statement with one affected row, and any statement
+// containing "fail" with an error
+func fakePGServer(t *testing.T, user, password string) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	serve := func(conn net.Conn) {
+		defer conn.Close()
+		backend := pgproto3.NewBackend(conn, conn)
+		if _, err := backend.ReceiveStartupMessage(); err != nil {
+			return
+		}
+		salt := [4]byte{1, 2, 3, 4}
+		backend.Send(&pgproto3.AuthenticationMD5Password{Salt: salt})
+		backend.Flush()
+		backend.SetAuthType(pgproto3.AuthTypeMD5Password)
+		msg, err := backend.Receive()
+		if err != nil {
+			return
+		}
+		inner := md5.Sum([]byte(password + user))
+		outer := md5.Sum(append([]byte(hex.EncodeToString(inner[:])), salt[:]...))
+		if got, ok := msg.(*pgproto3.PasswordMessage); !ok || got.Password != "md5"+hex.EncodeToString(outer[:]) {
+			backend.Send(&pgproto3.ErrorResponse{Severity: "FATAL", Code: "28P01", Message: "password authentication failed"})
+			backend.Flush()
+			return
+		}
+		backend.Send(&pgproto3.AuthenticationOk{})
+		backend.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+		backend.Flush()
+
+		statements := map[string]string{}
+		var query string
+		var params [][]byte
+		failed := false
+		columns := func(count int) *pgproto3.RowDescription {
+			fields := make([]pgproto3.FieldDescription, count)
+			for i := range fields {
+				fields[i] = pgproto3.FieldDescription{Name: []byte(fmt.Sprintf("c%d", i+1)), DataTypeOID: 25, DataTypeSize: -1, TypeModifier: -1}
+			}
+			return &pgproto3.RowDescription{Fields: fields}
+		}
+		for {
+			msg, err := backend.Receive()
+			if err != nil {
+				return
+			}
+			if _, sync := msg.(*pgproto3.Sync); failed && !sync {
+				continue
+			}
+			switch m := msg.(type) {
+			case *pgproto3.Terminate:
+				return
+			case *pgproto3.Parse:
+				if strings.Contains(m.Query, "fail") {
+					backend.Send(&pgproto3.ErrorResponse{Severity: "ERROR", Code: "42P01", Message: "relation does not exist"})
+					failed = true
+					continue
+				}
+				statements[m.Name], query = m.Query, m.Query
+				backend.Send(&pgproto3.ParseComplete{})
+			case *pgproto3.Describe:
+				if m.ObjectType == 'S' {
+					query = statements[m.Name]
+					oids := make([]uint32, len(pgPlaceholder.FindAllString(query, -1)))
+					for i := range oids {
+						oids[i] = 25
+					}
+					backend.Send(&pgproto3.ParameterDescription{ParameterOIDs: oids})
+				}
+				if strings.HasPrefix(query, "SELECT") {
+					backend.Send(columns(len(pgPlaceholder.FindAllString(query, -1))))
+				} else {
+					backend.Send(&pgproto3.NoData{})
+				}
+			case *pgproto3.Bind:
+				query = statements[m.PreparedStatement]
+				params = m.Parameters
+				backend.Send(&pgproto3.BindComplete{})
+			case *pgproto3.Execute:
+				if strings.HasPrefix(query, "SELECT") {
+					backend.Send(&pgproto3.DataRow{Values: params})
+					backend.Send(&pgproto3.CommandComplete{CommandTag: []byte("SELECT 1")})
+				} else {
+					backend.Send(&pgproto3.CommandComplete{CommandTag: []byte("UPDATE 1")})
+				}
+			case *pgproto3.Query:
+				backend.Send(&pgproto3.CommandComplete{CommandTag: []byte("CREATE TABLE")})
+				backend.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+				backend.Flush()
+			case *pgproto3.Sync:
+				failed = false
+				backend.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+				backend.Flush()
+			}
+		}
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serve(conn)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestPGClientQuery(t *testing.T) {
+	addr := fakePGServer(t, "indexer", "s3cret")
+	db, err := newPGClient("postgres://indexer:s3cret@"+addr+"/sih?sslmode=disable", 1, 2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+
+	when := time.Date(2025, 9, 20, 13, 19, 10, 0, time.UTC)
+	rows, err := db.Query(ctx, "SELECT $1, $2, $3, $4", "inc_1", nil, int64(42), when)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0].String(0) != "inc_1" || rows[0][1] != nil || rows[0].Int(2) != 42 || rows[0].String(3) != "2025-09-20T13:19:10Z" {
+		t.Fatalf("unexpected rows %v", rows)
+	}
+
+	// A server error leaves the connection usable
+	_, err = db.Query(ctx, "SELECT fail")
+	var serverErr *pgconn.PgError
+	if !errors.As(err, &serverErr) || serverErr.Code != "42P01" {
+		t.Fatalf("expected a server error, got %v", err)
+	}
+	if affected, err := db.Exec(ctx, "UPDATE x SET y = $1", "z"); err != nil || affected != 1 {
+		t.Fatalf("expected the pooled connection to be reused, got %d %v", affected, err)
+	}
+	if err := db.ExecScript(ctx, "CREATE TABLE IF NOT EXISTS x (y text); CREATE INDEX IF NOT EXISTS x_y ON x (y)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Query(ctx, "SELECT $1", struct{}{}); err == nil {
+		t.Error("expected an argument with no text form to be refused")
+	}
+}
+
+func TestPGClientAuthenticationFailure(t *testing.T) {
+	addr := fakePGServer(t, "indexer", "s3cret")
+	db, err := newPGClient("postgres://indexer:wrong@"+addr+"/sih?sslmode=disable", 1, 2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	err = db.Ping(context.Background())
+	var serverErr *pgconn.PgError
+	if !errors.As(err, &serverErr) || serverErr.Code != "28P01" {
+		t.Fatalf("expected an authentication error, got %v", err)
+	}
+}
+
+func TestNewPGClient(t *testing.T) {
+	db, err := newPGClient("postgres://indexer@db.internal/sih?sslmode=require", 4, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	config := db.pool.Config()
+	if db.addr != "db.internal:5432" || config.ConnConfig.Database != "sih" || config.MaxConns != 4 || config.ConnConfig.TLSConfig == nil {
+		t.Errorf("unexpected client %s %+v", db.addr, config)
+	}
+	if config.ConnConfig.RuntimeParams["application_name"] != "sih-gateway" || config.ConnConfig.ConnectTimeout != time.Second {
+		t.Errorf("unexpected connection settings %+v", config.ConnConfig)
+	}
+	for _, bad := range []string{"mysql://u@h/db", "postgres://h/db", "postgres://u@h/db?sslmode=sometimes"} {
+		if _, err := newPGClient(bad, 1, time.Second); err == nil {
+			t.Errorf("expected %s to be rejected", bad)
+		}
+	}
+}
@@ -0,0 +1,344 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	geofencePresencePrefix  = "sih:geofence:presence:"
+	geofenceOccupantsPrefix = "sih:geofence:occupants:"
+
+	geofenceZoneEntry   = "zone_entry"
+	geofenceZoneExit    = "zone_exit"
+	geofenceDwellBreach = "dwell_breach"
+	// geofenceRecentEntries is how many zone entries presence keeps for missing person searches
+	geofenceRecentEntries = 20
+)
+
+// GeofenceEvent is a change in the zones a tourist is inside. DwellSeconds is the time spent in the
+// zone by the exit or breach.
+type GeofenceEvent struct {
+	Type         string  `json:"type"`
+	DigitalID    string  `json:"digital_id"`
+	ZoneID       string  `json:"zone_id"`
+	Name         string  `json:"name"`
+	RiskLevel    string  `json:"risk_level"`
+	Latitude     float64 `json:"latitude"`
+	Longitude    float64 `json:"longitude"`
+	At           string  `json:"at"`
+	EnteredAt    string  `json:"entered_at"`
+	DwellSeconds int64   `json:"dwell_seconds,omitempty"`
+}
+
+// zoneVisit is a tourist's stay in one zone, with the zone's name and risk level kept for the exit
+type zoneVisit struct {
+	Name      string `json:"name"`
+	RiskLevel string `json:"risk_level"`
+	EnteredAt string `json:"entered_at"`
+	Breached  bool   `json:"breached,omitempty"`
+}
+
+// zonePresence is the active zones a tourist was inside at their last evaluated ping, and their
+// most recent zone entries, oldest first
+type zonePresence struct {
+	Zones   map[string]zoneVisit `json:"zones"`
+	LastAt  string               `json:"last_at,omitempty"`
+	Entries []GeofenceEvent      `json:"entries,omitempty"`
+}
+
+// presenceStore keeps each tourist's presence between uploads. It uses Redis when the document
+// cache is configured, so a tourist's uploads may reach any gateway instance.
+type presenceStore interface {
+	load(ctx context.Context, digitalID string) (zonePresence, error)
+	save(ctx context.Context, digitalID string, presence zonePresence) error
+	// occupants returns the tourists who were inside a zone at their last evaluated ping
+	occupants(ctx context.Context, zoneID string) ([]string, error)
+}
+
+// geofenceTracker turns streams of pings into zone entry, exit and dwell breach events
+type geofenceTracker struct {
+	store        presenceStore
+	dwellLimits  map[string]time.Duration
+	alertMinRisk int
+}
+
+var zoneTracker *geofenceTracker
+
+// initZoneTracker runs from initGeofence. GEOFENCE_DWELL_LIMITS is a list of risk=duration pairs.
+func initZoneTracker() {
+	ttl := getEnvDuration("GEOFENCE_PRESENCE_TTL", 24*time.Hour)
+	var store presenceStore = newMemoryPresenceStore(ttl)
+	backend := "memory"
+	if documentCache != nil {
+		store, backend = redisPresenceStore{documentCache, ttl}, "Redis"
+	}
+
+	dwellLimits, err := parseDwellLimits(getEnv("GEOFENCE_DWELL_LIMITS", "restricted=10m,high=30m"))
+	if err != nil {
+		panic(fmt.Errorf("GEOFENCE_DWELL_LIMITS %w", err))
+	}
+	minRisk := getEnv("GEOFENCE_ALERT_MIN_RISK", "high")
+	level := zoneRiskLevel(minRisk)
+	if level < 0 {
+		panic(fmt.Errorf("GEOFENCE_ALERT_MIN_RISK must be one of %s", strings.Join(zoneRiskLevels, ", ")))
+	}
+	zoneTracker = &geofenceTracker{store: store, dwellLimits: dwellLimits, alertMinRisk: level}
+	log.Printf("🗺️ Tracking zone presence in %s, alerting on %s+ entries and dwell breaches", backend, minRisk)
+}
+
+func parseDwellLimits(value string) (map[string]time.Duration, error) {
+	limits := map[string]time.Duration{}
+	for _, item := range splitList(value) {
+		risk, duration, _ := strings.Cut(item, "=")
+		if zoneRiskLevel(strings.TrimSpace(risk)) < 0 {
+			return nil, fmt.Errorf("has unknown risk level %q", risk)
+		}
+		limit, err := time.ParseDuration(strings.TrimSpace(duration))
+		if err != nil || limit <= 0 {
+			return nil, fmt.Errorf("has invalid duration for %s", risk)
+		}
+		limits[strings.TrimSpace(risk)] = limit
+	}
+	return limits, nil
+}
+
+// track evaluates the pings in recording order against the tourist's last known presence. Pings no
+// later than the last evaluated one are skipped, so retried and late uploads do not repeat events.
+func (t *geofenceTracker) track(ctx context.Context, digitalID string, pings []LocationPing) ([]GeofenceEvent, error) {
+	presence, err := t.store.load(ctx, digitalID)
+	if err != nil {
+		return nil, err
+	}
+	if presence.Zones == nil {
+		presence.Zones = map[string]zoneVisit{}
+	}
+	last, _ := time.Parse(time.RFC3339, presence.LastAt)
+
+	ordered := append([]LocationPing(nil), pings...)
+	sort.SliceStable(ordered, func(i, j int) bool { return ordered[i].RecordedAt < ordered[j].RecordedAt })
+
+	var events []GeofenceEvent
+	for _, ping := range ordered {
+		at, _ := time.Parse(time.RFC3339, ping.RecordedAt)
+		if !at.After(last) {
+			continue
+		}
+		last = at
+		lat, lng := *ping.Latitude, *ping.Longitude
+		event := func(kind, zoneID string, visit zoneVisit) GeofenceEvent {
+			e := GeofenceEvent{Type: kind, DigitalID: digitalID, ZoneID: zoneID, Name: visit.Name, RiskLevel: visit.RiskLevel, Latitude: lat, Longitude: lng, At: at.UTC().Format(time.RFC3339), EnteredAt: visit.EnteredAt}
+			if entered, err := time.Parse(time.RFC3339, visit.EnteredAt); err == nil && kind != geofenceZoneEntry {
+				e.DwellSeconds = int64(at.Sub(entered).Seconds())
+			}
+			return e
+		}
+
+		inside := map[string]ZoneMatch{}
+		for _, match := range geofence.evaluate(lat, lng, at).Zones {
+			if match.Active {
+				inside[match.ZoneID] = match
+			}
+		}
+		for _, zoneID := range sortedKeys(presence.Zones) {
+			if _, ok := inside[zoneID]; !ok {
+				events = append(events, event(geofenceZoneExit, zoneID, presence.Zones[zoneID]))
+				delete(presence.Zones, zoneID)
+			}
+		}
+		for _, zoneID := range sortedKeys(inside) {
+			if _, ok := presence.Zones[zoneID]; !ok {
+				visit := zoneVisit{Name: inside[zoneID].Name, RiskLevel: inside[zoneID].RiskLevel, EnteredAt: at.UTC().Format(time.RFC3339)}
+				presence.Zones[zoneID] = visit
+				entry := event(geofenceZoneEntry, zoneID, visit)
+				events = append(events, entry)
+				presence.Entries = append(presence.Entries, entry)
+			}
+		}
+		for _, zoneID := range sortedKeys(presence.Zones) {
+			visit := presence.Zones[zoneID]
+			limit, ok := t.dwellLimits[visit.RiskLevel]
+			if entered, _ := time.Parse(time.RFC3339, visit.EnteredAt); !ok || visit.Breached || at.Sub(entered) < limit {
+				continue
+			}
+			visit.Breached = true
+			presence.Zones[zoneID] = visit
+			events = append(events, event(geofenceDwellBreach, zoneID, visit))
+		}
+	}
+	if last.IsZero() {
+		return events, nil
+	}
+	if n := len(presence.Entries); n > geofenceRecentEntries {
+		presence.Entries = presence.Entries[n-geofenceRecentEntries:]
+	}
+	presence.LastAt = last.UTC().Format(time.RFC3339)
+	return events, t.store.save(ctx, digitalID, presence)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// alert pushes entries into zones at or above GEOFENCE_ALERT_MIN_RISK and every dwell breach to
+// the devices subscribed to the zone
+func (t *geofenceTracker) alert(ctx context.Context, events []GeofenceEvent) {
+	if pusher == nil {
+		return
+	}
+	org := tenantFromContext(ctx).org
+	for _, e := range events {
+		var title, body string
+		switch {
+		case e.Type == geofenceZoneEntry && zoneRiskLevel(e.RiskLevel) >= t.alertMinRisk:
+			title, body = "Tourist entered "+e.RiskLevel+" risk zone", "Tourist "+e.DigitalID+" entered "+e.Name
+		case e.Type == geofenceDwellBreach:
+			title, body = "Dwell limit exceeded", fmt.Sprintf("Tourist %s has been in %s for %s", e.DigitalID, e.Name, time.Duration(e.DwellSeconds)*time.Second)
+		default:
+			continue
+		}
+		go pusher.deliver(context.WithoutCancel(ctx), pushAlert{
+			key:   "geofence:" + e.DigitalID + ":" + e.ZoneID + ":" + e.EnteredAt + ":" + e.Type,
+			zone:  e.ZoneID,
+			org:   org,
+			title: title,
+			body:  body,
+			data:  map[string]string{"type": "geofence", "event": e.Type, "digital_id": e.DigitalID, "zone_id": e.ZoneID, "risk_level": e.RiskLevel, "at": e.At},
+		})
+	}
+}
+
+// redisPresenceStore keeps each tourist's presence under its own key, expiring after
+// GEOFENCE_PRESENCE_TTL without pings
+type redisPresenceStore struct {
+	redis *redisClient
+	ttl   time.Duration
+}
+
+func (s redisPresenceStore) load(ctx context.Context, digitalID string) (zonePresence, error) {
+	var presence zonePresence
+	data, err := s.redis.Get(ctx, geofencePresencePrefix+digitalID)
+	if errors.Is(err, errRedisNil) {
+		return presence, nil
+	}
+	if err != nil {
+		return presence, err
+	}
+	return presence, json.Unmarshal(data, &presence)
+}
+
+func (s redisPresenceStore) save(ctx context.Context, digitalID string, presence zonePresence) error {
+	data, err := json.Marshal(presence)
+	if err != nil {
+		return err
+	}
+	if err := s.redis.Set(ctx, geofencePresencePrefix+digitalID, data, s.ttl); err != nil {
+		return err
+	}
+	for zoneID := range presence.Zones {
+		key := geofenceOccupantsPrefix + zoneID
+		if _, err := s.redis.Do(ctx, "SADD", key, digitalID); err != nil {
+			return err
+		}
+		if _, err := s.redis.Do(ctx, "PEXPIRE", key, fmt.Sprint(s.ttl.Milliseconds())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// occupants reads a zone's set, which saves only add to, and drops the tourists who have since
+// left the zone or whose presence expired
+func (s redisPresenceStore) occupants(ctx context.Context, zoneID string) ([]string, error) {
+	reply, err := s.redis.Do(ctx, "SMEMBERS", geofenceOccupantsPrefix+zoneID)
+	if err != nil {
+		return nil, err
+	}
+	members, _ := reply.([]interface{})
+	var inside []string
+	for _, member := range members {
+		raw, _ := member.([]byte)
+		digitalID := string(raw)
+		presence, err := s.load(ctx, digitalID)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := presence.Zones[zoneID]; ok {
+			inside = append(inside, digitalID)
+			continue
+		}
+		s.redis.Do(ctx, "SREM", geofenceOccupantsPrefix+zoneID, digitalID)
+	}
+	sort.Strings(inside)
+	return inside, nil
+}
+
+// memoryPresenceStore serves a single gateway instance
+type memoryPresenceStore struct {
+	ttl time.Duration
+
+	mu       sync.Mutex
+	presence map[string]zonePresence
+	savedAt  map[string]time.Time
+}
+
+func newMemoryPresenceStore(ttl time.Duration) *memoryPresenceStore {
+	return &memoryPresenceStore{ttl: ttl, presence: map[string]zonePresence{}, savedAt: map[string]time.Time{}}
+}
+
+func (s *memoryPresenceStore) load(_ context.Context, digitalID string) (zonePresence, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if time.Since(s.savedAt[digitalID]) > s.ttl {
+		delete(s.presence, digitalID)
+		delete(s.savedAt, digitalID)
+		return zonePresence{}, nil
+	}
+	presence := s.presence[digitalID]
+	zones := make(map[string]zoneVisit, len(presence.Zones))
+	for id, visit := range presence.Zones {
+		zones[id] = visit
+	}
+	presence.Zones = zones
+	presence.Entries = slices.Clone(presence.Entries)
+	return presence, nil
+}
+
+func (s *memoryPresenceStore) save(_ context.Context, digitalID string, presence zonePresence) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.presence[digitalID], s.savedAt[digitalID] = presence, time.Now()
+	return nil
+}
+
+func (s *memoryPresenceStore) occupants(_ context.Context, zoneID string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var inside []string
+	for digitalID, presence := range s.presence {
+		if _, ok := presence.Zones[zoneID]; ok && time.Since(s.savedAt[digitalID]) <= s.ttl {
+			inside = append(inside, digitalID)
+		}
+	}
+	sort.Strings(inside)
+	return inside, nil
+}
@@ -0,0 +1,81 @@
+package gateway
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestGeofenceTracking(t *testing.T) {
+	geofence = newGeofenceIndex()
+	defer func() { geofence = nil }()
+	for _, doc := range []ZoneDocument{
+		{ZoneID: "falls", Name: "Elephant Falls gorge", Geometry: `{"type":"Circle","coordinates":[91.82,25.54],"radius":300}`, RiskLevel: "restricted"},
+		{ZoneID: "market", Name: "Police Bazar", Geometry: `{"type":"Polygon","coordinates":[[[91.87,25.57],[91.89,25.57],[91.89,25.58],[91.87,25.58],[91.87,25.57]]]}`, RiskLevel: "low"},
+	} {
+		z, err := newIndexedZone(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		geofence.put(z)
+	}
+
+	limits, err := parseDwellLimits("restricted=10m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseDwellLimits("extreme=5m"); err == nil {
+		t.Error("expected an unknown risk level rejected")
+	}
+	tracker := &geofenceTracker{store: newMemoryPresenceStore(time.Hour), dwellLimits: limits, alertMinRisk: zoneRiskLevel("high")}
+	ctx := context.Background()
+	start := time.Now().Add(-time.Hour).UTC()
+	ping := func(lat, lng float64, minutes int) LocationPing {
+		return LocationPing{Latitude: &lat, Longitude: &lng, RecordedAt: start.Add(time.Duration(minutes) * time.Minute).Format(time.RFC3339)}
+	}
+	types := func(events []GeofenceEvent) []string {
+		var out []string
+		for _, e := range events {
+			out = append(out, e.Type+":"+e.ZoneID)
+		}
+		return out
+	}
+	expect := func(events []GeofenceEvent, want ...string) {
+		t.Helper()
+		got := types(events)
+		if len(got) != len(want) {
+			t.Fatalf("got events %v, expected %v", got, want)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("got events %v, expected %v", got, want)
+			}
+		}
+	}
+
+	// Uploaded out of order: the market visit, then the walk into the gorge
+	events, err := tracker.track(ctx, "T-1", []LocationPing{ping(25.541, 91.821, 20), ping(25.575, 91.88, 0), ping(25.54, 91.82, 12)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect(events, "zone_entry:market", "zone_exit:market", "zone_entry:falls")
+	if events[1].DwellSeconds != 12*60 {
+		t.Errorf("expected twelve minutes in the market, got %+v", events[1])
+	}
+
+	// A retried batch repeats nothing; staying past the limit breaches once
+	events, _ = tracker.track(ctx, "T-1", []LocationPing{ping(25.54, 91.82, 12), ping(25.54, 91.82, 23), ping(25.54, 91.82, 30)})
+	expect(events, "dwell_breach:falls")
+	if events[0].EnteredAt != start.Add(12*time.Minute).Format(time.RFC3339) || events[0].DwellSeconds != 11*60 {
+		t.Errorf("unexpected breach %+v", events[0])
+	}
+	events, _ = tracker.track(ctx, "T-1", []LocationPing{ping(25.50, 91.82, 40)})
+	expect(events, "zone_exit:falls")
+	if presence, _ := tracker.store.load(ctx, "T-1"); len(presence.Entries) != 2 || presence.Entries[1].ZoneID != "falls" {
+		t.Errorf("expected both entries kept for missing person searches, got %+v", presence.Entries)
+	}
+
+	// Another tourist starts with no presence
+	events, _ = tracker.track(ctx, "T-2", []LocationPing{ping(25.54, 91.82, 0)})
+	expect(events, "zone_entry:falls")
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	metadataContractName = "org.hyperledger.fabric"
+	watchdogInterval     = 5 * time.Second
+	watchdogStaleAfter   = 3 * watchdogInterval
+)
+
+var (
+	eventListenerRunning atomic.Bool
+	watchdogTick         atomic.Int64
+)
+
+// startWatchdog records a heartbeat on a fixed interval so /livez can detect a wedged process
+func startWatchdog(ctx context.Context) {
+	watchdogTick.Store(time.Now().UnixNano())
+	ticker := time.NewTicker(watchdogInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			watchdogTick.Store(now.UnixNano())
+		}
+	}
+}
+
+// checkChaincode evaluates the contract API metadata function to prove the chaincode container answers
+func checkChaincode(ctx context.Context) DependencyStatus {
+	if defaultTarget == nil {
+		return DependencyStatus{Status: statusDown, Error: "network not initialised"}
+	}
+
+	chaincodeName := defaultTarget.Chaincode
+	start := time.Now()
+	_, err := defaultTarget.network.GetContractWithName(chaincodeName, metadataContractName).EvaluateWithContext(ctx, "GetMetadata")
+	latency := time.Since(start).Milliseconds()
+	if err != nil {
+		return DependencyStatus{Status: statusDown, LatencyMS: latency, Error: fmt.Sprintf("chaincode %s unreachable: %v", chaincodeName, err)}
+	}
+
+	return DependencyStatus{Status: statusOK, LatencyMS: latency, Detail: chaincodeName}
+}
+
+// livenessCheck fails only when the process itself is unhealthy, never because Fabric is down
+func livenessCheck(c *gin.Context) {
+	checks := gin.H{}
+	healthy := true
+
+	lastTick := time.Unix(0, watchdogTick.Load())
+	if since := time.Since(lastTick); since > watchdogStaleAfter {
+		healthy = false
+		checks["watchdog"] = DependencyStatus{Status: statusDown, Error: fmt.Sprintf("no heartbeat for %s", since.Round(time.Second))}
+	} else {
+		checks["watchdog"] = DependencyStatus{Status: statusOK}
+	}
+
+	if eventListenerRunning.Load() {
+		checks["event_listener"] = DependencyStatus{Status: statusOK}
+	} else {
+		healthy = false
+		checks["event_listener"] = DependencyStatus{Status: statusDown, Error: "chaincode event listener is not running"}
+	}
+
+	respondProbe(c, healthy, checks)
+}
+
+// readinessCheck fails when requests cannot be served because Fabric is unreachable
+func readinessCheck(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), healthCheckTimeout)
+	defer cancel()
+
+	gatewayStatus := checkGatewayConnection()
+	chaincodeStatus := checkChaincode(ctx)
+
+	ready := gatewayStatus.Status != statusDown && chaincodeStatus.Status != statusDown
+	respondProbe(c, ready, gin.H{
+		"gateway":   gatewayStatus,
+		"chaincode": chaincodeStatus,
+	})
+}
+
+func respondProbe(c *gin.Context, ok bool, checks gin.H) {
+	status, httpStatus := statusOK, http.StatusOK
+	if !ok {
+		status, httpStatus = statusDown, http.StatusServiceUnavailable
+	}
+
+	c.JSON(httpStatus, gin.H{
+		"status":    status,
+		"timestamp": time.Now().Format(time.RFC3339),
+		"checks":    checks,
+	})
+}
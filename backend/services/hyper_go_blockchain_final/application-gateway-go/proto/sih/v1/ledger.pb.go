@@ -0,0 +1,1848 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.9
+// 	protoc        (unknown)
+// source: sih/v1/ledger.proto
+
+package sihv1
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// TransactionReceipt identifies a committed transaction.
+type TransactionReceipt struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	TxId          string                 `protobuf:"bytes,1,opt,name=tx_id,json=txId,proto3" json:"tx_id,omitempty"`
+	BlockNumber   uint64                 `protobuf:"varint,2,opt,name=block_number,json=blockNumber,proto3" json:"block_number,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TransactionReceipt) Reset() {
+	*x = TransactionReceipt{}
+	mi := &file_sih_v1_ledger_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TransactionReceipt) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TransactionReceipt) ProtoMessage() {}
+
+func (x *TransactionReceipt) ProtoReflect() protoreflect.Message {
+	mi := &file_sih_v1_ledger_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TransactionReceipt.ProtoReflect.Descriptor instead.
+func (*TransactionReceipt) Descriptor() ([]byte, []int) {
+	return file_sih_v1_ledger_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *TransactionReceipt) GetTxId() string {
+	if x != nil {
+		return x.TxId
+	}
+	return ""
+}
+
+func (x *TransactionReceipt) GetBlockNumber() uint64 {
+	if x != nil {
+		return x.BlockNumber
+	}
+	return 0
+}
+
+type GetDocumentRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetDocumentRequest) Reset() {
+	*x = GetDocumentRequest{}
+	mi := &file_sih_v1_ledger_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetDocumentRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetDocumentRequest) ProtoMessage() {}
+
+func (x *GetDocumentRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_sih_v1_ledger_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetDocumentRequest.ProtoReflect.Descriptor instead.
+func (*GetDocumentRequest) Descriptor() ([]byte, []int) {
+	return file_sih_v1_ledger_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *GetDocumentRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+type DeleteDocumentRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Actor         string                 `protobuf:"bytes,2,opt,name=actor,proto3" json:"actor,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteDocumentRequest) Reset() {
+	*x = DeleteDocumentRequest{}
+	mi := &file_sih_v1_ledger_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteDocumentRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteDocumentRequest) ProtoMessage() {}
+
+func (x *DeleteDocumentRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_sih_v1_ledger_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteDocumentRequest.ProtoReflect.Descriptor instead.
+func (*DeleteDocumentRequest) Descriptor() ([]byte, []int) {
+	return file_sih_v1_ledger_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *DeleteDocumentRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *DeleteDocumentRequest) GetActor() string {
+	if x != nil {
+		return x.Actor
+	}
+	return ""
+}
+
+type CreateDIDRequest struct {
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	DigitalId string                 `protobuf:"bytes,1,opt,name=digital_id,json=digitalId,proto3" json:"digital_id,omitempty"`
+	// SHA-256 hex digest of the consent document.
+	ConsentHash string `protobuf:"bytes,2,opt,name=consent_hash,json=consentHash,proto3" json:"consent_hash,omitempty"`
+	// RFC 3339 timestamp in the future.
+	ExpiresAt     string `protobuf:"bytes,3,opt,name=expires_at,json=expiresAt,proto3" json:"expires_at,omitempty"`
+	Issuer        string `protobuf:"bytes,4,opt,name=issuer,proto3" json:"issuer,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CreateDIDRequest) Reset() {
+	*x = CreateDIDRequest{}
+	mi := &file_sih_v1_ledger_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CreateDIDRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CreateDIDRequest) ProtoMessage() {}
+
+func (x *CreateDIDRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_sih_v1_ledger_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CreateDIDRequest.ProtoReflect.Descriptor instead.
+func (*CreateDIDRequest) Descriptor() ([]byte, []int) {
+	return file_sih_v1_ledger_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *CreateDIDRequest) GetDigitalId() string {
+	if x != nil {
+		return x.DigitalId
+	}
+	return ""
+}
+
+func (x *CreateDIDRequest) GetConsentHash() string {
+	if x != nil {
+		return x.ConsentHash
+	}
+	return ""
+}
+
+func (x *CreateDIDRequest) GetExpiresAt() string {
+	if x != nil {
+		return x.ExpiresAt
+	}
+	return ""
+}
+
+func (x *CreateDIDRequest) GetIssuer() string {
+	if x != nil {
+		return x.Issuer
+	}
+	return ""
+}
+
+type UpdateDIDRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	DigitalId     string                 `protobuf:"bytes,1,opt,name=digital_id,json=digitalId,proto3" json:"digital_id,omitempty"`
+	ConsentHash   string                 `protobuf:"bytes,2,opt,name=consent_hash,json=consentHash,proto3" json:"consent_hash,omitempty"`
+	ExpiresAt     string                 `protobuf:"bytes,3,opt,name=expires_at,json=expiresAt,proto3" json:"expires_at,omitempty"`
+	Updater       string                 `protobuf:"bytes,4,opt,name=updater,proto3" json:"updater,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UpdateDIDRequest) Reset() {
+	*x = UpdateDIDRequest{}
+	mi := &file_sih_v1_ledger_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UpdateDIDRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UpdateDIDRequest) ProtoMessage() {}
+
+func (x *UpdateDIDRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_sih_v1_ledger_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UpdateDIDRequest.ProtoReflect.Descriptor instead.
+func (*UpdateDIDRequest) Descriptor() ([]byte, []int) {
+	return file_sih_v1_ledger_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *UpdateDIDRequest) GetDigitalId() string {
+	if x != nil {
+		return x.DigitalId
+	}
+	return ""
+}
+
+func (x *UpdateDIDRequest) GetConsentHash() string {
+	if x != nil {
+		return x.ConsentHash
+	}
+	return ""
+}
+
+func (x *UpdateDIDRequest) GetExpiresAt() string {
+	if x != nil {
+		return x.ExpiresAt
+	}
+	return ""
+}
+
+func (x *UpdateDIDRequest) GetUpdater() string {
+	if x != nil {
+		return x.Updater
+	}
+	return ""
+}
+
+type DIDDocument struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	DocType       string                 `protobuf:"bytes,1,opt,name=doc_type,json=docType,proto3" json:"doc_type,omitempty"`
+	DigitalId     string                 `protobuf:"bytes,2,opt,name=digital_id,json=digitalId,proto3" json:"digital_id,omitempty"`
+	ConsentHash   string                 `protobuf:"bytes,3,opt,name=consent_hash,json=consentHash,proto3" json:"consent_hash,omitempty"`
+	IssuedAt      string                 `protobuf:"bytes,4,opt,name=issued_at,json=issuedAt,proto3" json:"issued_at,omitempty"`
+	ExpiresAt     string                 `protobuf:"bytes,5,opt,name=expires_at,json=expiresAt,proto3" json:"expires_at,omitempty"`
+	Issuer        string                 `protobuf:"bytes,6,opt,name=issuer,proto3" json:"issuer,omitempty"`
+	TxId          string                 `protobuf:"bytes,7,opt,name=tx_id,json=txId,proto3" json:"tx_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DIDDocument) Reset() {
+	*x = DIDDocument{}
+	mi := &file_sih_v1_ledger_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DIDDocument) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DIDDocument) ProtoMessage() {}
+
+func (x *DIDDocument) ProtoReflect() protoreflect.Message {
+	mi := &file_sih_v1_ledger_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DIDDocument.ProtoReflect.Descriptor instead.
+func (*DIDDocument) Descriptor() ([]byte, []int) {
+	return file_sih_v1_ledger_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *DIDDocument) GetDocType() string {
+	if x != nil {
+		return x.DocType
+	}
+	return ""
+}
+
+func (x *DIDDocument) GetDigitalId() string {
+	if x != nil {
+		return x.DigitalId
+	}
+	return ""
+}
+
+func (x *DIDDocument) GetConsentHash() string {
+	if x != nil {
+		return x.ConsentHash
+	}
+	return ""
+}
+
+func (x *DIDDocument) GetIssuedAt() string {
+	if x != nil {
+		return x.IssuedAt
+	}
+	return ""
+}
+
+func (x *DIDDocument) GetExpiresAt() string {
+	if x != nil {
+		return x.ExpiresAt
+	}
+	return ""
+}
+
+func (x *DIDDocument) GetIssuer() string {
+	if x != nil {
+		return x.Issuer
+	}
+	return ""
+}
+
+func (x *DIDDocument) GetTxId() string {
+	if x != nil {
+		return x.TxId
+	}
+	return ""
+}
+
+type DIDVerification struct {
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	DigitalId string                 `protobuf:"bytes,1,opt,name=digital_id,json=digitalId,proto3" json:"digital_id,omitempty"`
+	Valid     bool                   `protobuf:"varint,2,opt,name=valid,proto3" json:"valid,omitempty"`
+	Exists    bool                   `protobuf:"varint,3,opt,name=exists,proto3" json:"exists,omitempty"`
+	Expired   bool                   `protobuf:"varint,4,opt,name=expired,proto3" json:"expired,omitempty"`
+	Revoked   bool                   `protobuf:"varint,5,opt,name=revoked,proto3" json:"revoked,omitempty"`
+	// One of not_found, revoked, expired or invalid_expiry when valid is false.
+	Reason     string `protobuf:"bytes,6,opt,name=reason,proto3" json:"reason,omitempty"`
+	Issuer     string `protobuf:"bytes,7,opt,name=issuer,proto3" json:"issuer,omitempty"`
+	IssuedAt   string `protobuf:"bytes,8,opt,name=issued_at,json=issuedAt,proto3" json:"issued_at,omitempty"`
+	ExpiresAt  string `protobuf:"bytes,9,opt,name=expires_at,json=expiresAt,proto3" json:"expires_at,omitempty"`
+	VerifiedAt string `protobuf:"bytes,10,opt,name=verified_at,json=verifiedAt,proto3" json:"verified_at,omitempty"`
+	// Transaction that last wrote the DID, or that revoked it.
+	TxId          string `protobuf:"bytes,11,opt,name=tx_id,json=txId,proto3" json:"tx_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DIDVerification) Reset() {
+	*x = DIDVerification{}
+	mi := &file_sih_v1_ledger_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DIDVerification) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DIDVerification) ProtoMessage() {}
+
+func (x *DIDVerification) ProtoReflect() protoreflect.Message {
+	mi := &file_sih_v1_ledger_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DIDVerification.ProtoReflect.Descriptor instead.
+func (*DIDVerification) Descriptor() ([]byte, []int) {
+	return file_sih_v1_ledger_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *DIDVerification) GetDigitalId() string {
+	if x != nil {
+		return x.DigitalId
+	}
+	return ""
+}
+
+func (x *DIDVerification) GetValid() bool {
+	if x != nil {
+		return x.Valid
+	}
+	return false
+}
+
+func (x *DIDVerification) GetExists() bool {
+	if x != nil {
+		return x.Exists
+	}
+	return false
+}
+
+func (x *DIDVerification) GetExpired() bool {
+	if x != nil {
+		return x.Expired
+	}
+	return false
+}
+
+func (x *DIDVerification) GetRevoked() bool {
+	if x != nil {
+		return x.Revoked
+	}
+	return false
+}
+
+func (x *DIDVerification) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+func (x *DIDVerification) GetIssuer() string {
+	if x != nil {
+		return x.Issuer
+	}
+	return ""
+}
+
+func (x *DIDVerification) GetIssuedAt() string {
+	if x != nil {
+		return x.IssuedAt
+	}
+	return ""
+}
+
+func (x *DIDVerification) GetExpiresAt() string {
+	if x != nil {
+		return x.ExpiresAt
+	}
+	return ""
+}
+
+func (x *DIDVerification) GetVerifiedAt() string {
+	if x != nil {
+		return x.VerifiedAt
+	}
+	return ""
+}
+
+func (x *DIDVerification) GetTxId() string {
+	if x != nil {
+		return x.TxId
+	}
+	return ""
+}
+
+type CreateIncidentRequest struct {
+	state               protoimpl.MessageState `protogen:"open.v1"`
+	IncidentId          string                 `protobuf:"bytes,1,opt,name=incident_id,json=incidentId,proto3" json:"incident_id,omitempty"`
+	IncidentSummaryHash string                 `protobuf:"bytes,2,opt,name=incident_summary_hash,json=incidentSummaryHash,proto3" json:"incident_summary_hash,omitempty"`
+	Reporter            string                 `protobuf:"bytes,3,opt,name=reporter,proto3" json:"reporter,omitempty"`
+	// One of low, medium, high or critical; optional.
+	Severity      string `protobuf:"bytes,4,opt,name=severity,proto3" json:"severity,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CreateIncidentRequest) Reset() {
+	*x = CreateIncidentRequest{}
+	mi := &file_sih_v1_ledger_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CreateIncidentRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CreateIncidentRequest) ProtoMessage() {}
+
+func (x *CreateIncidentRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_sih_v1_ledger_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CreateIncidentRequest.ProtoReflect.Descriptor instead.
+func (*CreateIncidentRequest) Descriptor() ([]byte, []int) {
+	return file_sih_v1_ledger_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *CreateIncidentRequest) GetIncidentId() string {
+	if x != nil {
+		return x.IncidentId
+	}
+	return ""
+}
+
+func (x *CreateIncidentRequest) GetIncidentSummaryHash() string {
+	if x != nil {
+		return x.IncidentSummaryHash
+	}
+	return ""
+}
+
+func (x *CreateIncidentRequest) GetReporter() string {
+	if x != nil {
+		return x.Reporter
+	}
+	return ""
+}
+
+func (x *CreateIncidentRequest) GetSeverity() string {
+	if x != nil {
+		return x.Severity
+	}
+	return ""
+}
+
+type UpdateIncidentRequest struct {
+	state               protoimpl.MessageState `protogen:"open.v1"`
+	IncidentId          string                 `protobuf:"bytes,1,opt,name=incident_id,json=incidentId,proto3" json:"incident_id,omitempty"`
+	IncidentSummaryHash string                 `protobuf:"bytes,2,opt,name=incident_summary_hash,json=incidentSummaryHash,proto3" json:"incident_summary_hash,omitempty"`
+	Updater             string                 `protobuf:"bytes,3,opt,name=updater,proto3" json:"updater,omitempty"`
+	unknownFields       protoimpl.UnknownFields
+	sizeCache           protoimpl.SizeCache
+}
+
+func (x *UpdateIncidentRequest) Reset() {
+	*x = UpdateIncidentRequest{}
+	mi := &file_sih_v1_ledger_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UpdateIncidentRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UpdateIncidentRequest) ProtoMessage() {}
+
+func (x *UpdateIncidentRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_sih_v1_ledger_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UpdateIncidentRequest.ProtoReflect.Descriptor instead.
+func (*UpdateIncidentRequest) Descriptor() ([]byte, []int) {
+	return file_sih_v1_ledger_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *UpdateIncidentRequest) GetIncidentId() string {
+	if x != nil {
+		return x.IncidentId
+	}
+	return ""
+}
+
+func (x *UpdateIncidentRequest) GetIncidentSummaryHash() string {
+	if x != nil {
+		return x.IncidentSummaryHash
+	}
+	return ""
+}
+
+func (x *UpdateIncidentRequest) GetUpdater() string {
+	if x != nil {
+		return x.Updater
+	}
+	return ""
+}
+
+type IncidentDocument struct {
+	state               protoimpl.MessageState `protogen:"open.v1"`
+	DocType             string                 `protobuf:"bytes,1,opt,name=doc_type,json=docType,proto3" json:"doc_type,omitempty"`
+	IncidentId          string                 `protobuf:"bytes,2,opt,name=incident_id,json=incidentId,proto3" json:"incident_id,omitempty"`
+	IncidentSummaryHash string                 `protobuf:"bytes,3,opt,name=incident_summary_hash,json=incidentSummaryHash,proto3" json:"incident_summary_hash,omitempty"`
+	CreatedAt           string                 `protobuf:"bytes,4,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+	Reporter            string                 `protobuf:"bytes,5,opt,name=reporter,proto3" json:"reporter,omitempty"`
+	TxId                string                 `protobuf:"bytes,6,opt,name=tx_id,json=txId,proto3" json:"tx_id,omitempty"`
+	Status              string                 `protobuf:"bytes,7,opt,name=status,proto3" json:"status,omitempty"`
+	Severity            string                 `protobuf:"bytes,8,opt,name=severity,proto3" json:"severity,omitempty"`
+	unknownFields       protoimpl.UnknownFields
+	sizeCache           protoimpl.SizeCache
+}
+
+func (x *IncidentDocument) Reset() {
+	*x = IncidentDocument{}
+	mi := &file_sih_v1_ledger_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *IncidentDocument) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*IncidentDocument) ProtoMessage() {}
+
+func (x *IncidentDocument) ProtoReflect() protoreflect.Message {
+	mi := &file_sih_v1_ledger_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use IncidentDocument.ProtoReflect.Descriptor instead.
+func (*IncidentDocument) Descriptor() ([]byte, []int) {
+	return file_sih_v1_ledger_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *IncidentDocument) GetDocType() string {
+	if x != nil {
+		return x.DocType
+	}
+	return ""
+}
+
+func (x *IncidentDocument) GetIncidentId() string {
+	if x != nil {
+		return x.IncidentId
+	}
+	return ""
+}
+
+func (x *IncidentDocument) GetIncidentSummaryHash() string {
+	if x != nil {
+		return x.IncidentSummaryHash
+	}
+	return ""
+}
+
+func (x *IncidentDocument) GetCreatedAt() string {
+	if x != nil {
+		return x.CreatedAt
+	}
+	return ""
+}
+
+func (x *IncidentDocument) GetReporter() string {
+	if x != nil {
+		return x.Reporter
+	}
+	return ""
+}
+
+func (x *IncidentDocument) GetTxId() string {
+	if x != nil {
+		return x.TxId
+	}
+	return ""
+}
+
+func (x *IncidentDocument) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *IncidentDocument) GetSeverity() string {
+	if x != nil {
+		return x.Severity
+	}
+	return ""
+}
+
+type SearchIncidentsRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// RFC3339 bounds on created_at; from is inclusive and to exclusive.
+	From     string `protobuf:"bytes,1,opt,name=from,proto3" json:"from,omitempty"`
+	To       string `protobuf:"bytes,2,opt,name=to,proto3" json:"to,omitempty"`
+	Status   string `protobuf:"bytes,3,opt,name=status,proto3" json:"status,omitempty"`
+	Severity string `protobuf:"bytes,4,opt,name=severity,proto3" json:"severity,omitempty"`
+	Reporter string `protobuf:"bytes,5,opt,name=reporter,proto3" json:"reporter,omitempty"`
+	// Defaults to 20, at most 100.
+	PageSize int32 `protobuf:"varint,6,opt,name=page_size,json=pageSize,proto3" json:"page_size,omitempty"`
+	// Bookmark from the previous page.
+	Bookmark      string `protobuf:"bytes,7,opt,name=bookmark,proto3" json:"bookmark,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SearchIncidentsRequest) Reset() {
+	*x = SearchIncidentsRequest{}
+	mi := &file_sih_v1_ledger_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SearchIncidentsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SearchIncidentsRequest) ProtoMessage() {}
+
+func (x *SearchIncidentsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_sih_v1_ledger_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SearchIncidentsRequest.ProtoReflect.Descriptor instead.
+func (*SearchIncidentsRequest) Descriptor() ([]byte, []int) {
+	return file_sih_v1_ledger_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *SearchIncidentsRequest) GetFrom() string {
+	if x != nil {
+		return x.From
+	}
+	return ""
+}
+
+func (x *SearchIncidentsRequest) GetTo() string {
+	if x != nil {
+		return x.To
+	}
+	return ""
+}
+
+func (x *SearchIncidentsRequest) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *SearchIncidentsRequest) GetSeverity() string {
+	if x != nil {
+		return x.Severity
+	}
+	return ""
+}
+
+func (x *SearchIncidentsRequest) GetReporter() string {
+	if x != nil {
+		return x.Reporter
+	}
+	return ""
+}
+
+func (x *SearchIncidentsRequest) GetPageSize() int32 {
+	if x != nil {
+		return x.PageSize
+	}
+	return 0
+}
+
+func (x *SearchIncidentsRequest) GetBookmark() string {
+	if x != nil {
+		return x.Bookmark
+	}
+	return ""
+}
+
+type IncidentPage struct {
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	Incidents []*IncidentDocument    `protobuf:"bytes,1,rep,name=incidents,proto3" json:"incidents,omitempty"`
+	// Empty when there are no more results.
+	Bookmark      string `protobuf:"bytes,2,opt,name=bookmark,proto3" json:"bookmark,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *IncidentPage) Reset() {
+	*x = IncidentPage{}
+	mi := &file_sih_v1_ledger_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *IncidentPage) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*IncidentPage) ProtoMessage() {}
+
+func (x *IncidentPage) ProtoReflect() protoreflect.Message {
+	mi := &file_sih_v1_ledger_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use IncidentPage.ProtoReflect.Descriptor instead.
+func (*IncidentPage) Descriptor() ([]byte, []int) {
+	return file_sih_v1_ledger_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *IncidentPage) GetIncidents() []*IncidentDocument {
+	if x != nil {
+		return x.Incidents
+	}
+	return nil
+}
+
+func (x *IncidentPage) GetBookmark() string {
+	if x != nil {
+		return x.Bookmark
+	}
+	return ""
+}
+
+type CreateEvidenceRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	EvidenceId    string                 `protobuf:"bytes,1,opt,name=evidence_id,json=evidenceId,proto3" json:"evidence_id,omitempty"`
+	EvidenceHash  string                 `protobuf:"bytes,2,opt,name=evidence_hash,json=evidenceHash,proto3" json:"evidence_hash,omitempty"`
+	IncidentId    string                 `protobuf:"bytes,3,opt,name=incident_id,json=incidentId,proto3" json:"incident_id,omitempty"`
+	MediaType     string                 `protobuf:"bytes,4,opt,name=media_type,json=mediaType,proto3" json:"media_type,omitempty"`
+	UploadedBy    string                 `protobuf:"bytes,5,opt,name=uploaded_by,json=uploadedBy,proto3" json:"uploaded_by,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CreateEvidenceRequest) Reset() {
+	*x = CreateEvidenceRequest{}
+	mi := &file_sih_v1_ledger_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CreateEvidenceRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CreateEvidenceRequest) ProtoMessage() {}
+
+func (x *CreateEvidenceRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_sih_v1_ledger_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CreateEvidenceRequest.ProtoReflect.Descriptor instead.
+func (*CreateEvidenceRequest) Descriptor() ([]byte, []int) {
+	return file_sih_v1_ledger_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *CreateEvidenceRequest) GetEvidenceId() string {
+	if x != nil {
+		return x.EvidenceId
+	}
+	return ""
+}
+
+func (x *CreateEvidenceRequest) GetEvidenceHash() string {
+	if x != nil {
+		return x.EvidenceHash
+	}
+	return ""
+}
+
+func (x *CreateEvidenceRequest) GetIncidentId() string {
+	if x != nil {
+		return x.IncidentId
+	}
+	return ""
+}
+
+func (x *CreateEvidenceRequest) GetMediaType() string {
+	if x != nil {
+		return x.MediaType
+	}
+	return ""
+}
+
+func (x *CreateEvidenceRequest) GetUploadedBy() string {
+	if x != nil {
+		return x.UploadedBy
+	}
+	return ""
+}
+
+type UpdateEvidenceRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	EvidenceId    string                 `protobuf:"bytes,1,opt,name=evidence_id,json=evidenceId,proto3" json:"evidence_id,omitempty"`
+	EvidenceHash  string                 `protobuf:"bytes,2,opt,name=evidence_hash,json=evidenceHash,proto3" json:"evidence_hash,omitempty"`
+	MediaType     string                 `protobuf:"bytes,3,opt,name=media_type,json=mediaType,proto3" json:"media_type,omitempty"`
+	Updater       string                 `protobuf:"bytes,4,opt,name=updater,proto3" json:"updater,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UpdateEvidenceRequest) Reset() {
+	*x = UpdateEvidenceRequest{}
+	mi := &file_sih_v1_ledger_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UpdateEvidenceRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UpdateEvidenceRequest) ProtoMessage() {}
+
+func (x *UpdateEvidenceRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_sih_v1_ledger_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UpdateEvidenceRequest.ProtoReflect.Descriptor instead.
+func (*UpdateEvidenceRequest) Descriptor() ([]byte, []int) {
+	return file_sih_v1_ledger_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *UpdateEvidenceRequest) GetEvidenceId() string {
+	if x != nil {
+		return x.EvidenceId
+	}
+	return ""
+}
+
+func (x *UpdateEvidenceRequest) GetEvidenceHash() string {
+	if x != nil {
+		return x.EvidenceHash
+	}
+	return ""
+}
+
+func (x *UpdateEvidenceRequest) GetMediaType() string {
+	if x != nil {
+		return x.MediaType
+	}
+	return ""
+}
+
+func (x *UpdateEvidenceRequest) GetUpdater() string {
+	if x != nil {
+		return x.Updater
+	}
+	return ""
+}
+
+type EvidenceDocument struct {
+	state        protoimpl.MessageState `protogen:"open.v1"`
+	DocType      string                 `protobuf:"bytes,1,opt,name=doc_type,json=docType,proto3" json:"doc_type,omitempty"`
+	EvidenceId   string                 `protobuf:"bytes,2,opt,name=evidence_id,json=evidenceId,proto3" json:"evidence_id,omitempty"`
+	EvidenceHash string                 `protobuf:"bytes,3,opt,name=evidence_hash,json=evidenceHash,proto3" json:"evidence_hash,omitempty"`
+	IncidentId   string                 `protobuf:"bytes,4,opt,name=incident_id,json=incidentId,proto3" json:"incident_id,omitempty"`
+	MediaType    string                 `protobuf:"bytes,5,opt,name=media_type,json=mediaType,proto3" json:"media_type,omitempty"`
+	UploadedBy   string                 `protobuf:"bytes,6,opt,name=uploaded_by,json=uploadedBy,proto3" json:"uploaded_by,omitempty"`
+	CreatedAt    string                 `protobuf:"bytes,7,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+	// IPFS content identifier, set when the file is held in IPFS.
+	Cid           string `protobuf:"bytes,8,opt,name=cid,proto3" json:"cid,omitempty"`
+	TxId          string `protobuf:"bytes,9,opt,name=tx_id,json=txId,proto3" json:"tx_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *EvidenceDocument) Reset() {
+	*x = EvidenceDocument{}
+	mi := &file_sih_v1_ledger_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *EvidenceDocument) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EvidenceDocument) ProtoMessage() {}
+
+func (x *EvidenceDocument) ProtoReflect() protoreflect.Message {
+	mi := &file_sih_v1_ledger_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EvidenceDocument.ProtoReflect.Descriptor instead.
+func (*EvidenceDocument) Descriptor() ([]byte, []int) {
+	return file_sih_v1_ledger_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *EvidenceDocument) GetDocType() string {
+	if x != nil {
+		return x.DocType
+	}
+	return ""
+}
+
+func (x *EvidenceDocument) GetEvidenceId() string {
+	if x != nil {
+		return x.EvidenceId
+	}
+	return ""
+}
+
+func (x *EvidenceDocument) GetEvidenceHash() string {
+	if x != nil {
+		return x.EvidenceHash
+	}
+	return ""
+}
+
+func (x *EvidenceDocument) GetIncidentId() string {
+	if x != nil {
+		return x.IncidentId
+	}
+	return ""
+}
+
+func (x *EvidenceDocument) GetMediaType() string {
+	if x != nil {
+		return x.MediaType
+	}
+	return ""
+}
+
+func (x *EvidenceDocument) GetUploadedBy() string {
+	if x != nil {
+		return x.UploadedBy
+	}
+	return ""
+}
+
+func (x *EvidenceDocument) GetCreatedAt() string {
+	if x != nil {
+		return x.CreatedAt
+	}
+	return ""
+}
+
+func (x *EvidenceDocument) GetCid() string {
+	if x != nil {
+		return x.Cid
+	}
+	return ""
+}
+
+func (x *EvidenceDocument) GetTxId() string {
+	if x != nil {
+		return x.TxId
+	}
+	return ""
+}
+
+type ListEvidenceByIncidentRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	IncidentId    string                 `protobuf:"bytes,1,opt,name=incident_id,json=incidentId,proto3" json:"incident_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListEvidenceByIncidentRequest) Reset() {
+	*x = ListEvidenceByIncidentRequest{}
+	mi := &file_sih_v1_ledger_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListEvidenceByIncidentRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListEvidenceByIncidentRequest) ProtoMessage() {}
+
+func (x *ListEvidenceByIncidentRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_sih_v1_ledger_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListEvidenceByIncidentRequest.ProtoReflect.Descriptor instead.
+func (*ListEvidenceByIncidentRequest) Descriptor() ([]byte, []int) {
+	return file_sih_v1_ledger_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *ListEvidenceByIncidentRequest) GetIncidentId() string {
+	if x != nil {
+		return x.IncidentId
+	}
+	return ""
+}
+
+type EvidenceList struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Evidence      []*EvidenceDocument    `protobuf:"bytes,1,rep,name=evidence,proto3" json:"evidence,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *EvidenceList) Reset() {
+	*x = EvidenceList{}
+	mi := &file_sih_v1_ledger_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *EvidenceList) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EvidenceList) ProtoMessage() {}
+
+func (x *EvidenceList) ProtoReflect() protoreflect.Message {
+	mi := &file_sih_v1_ledger_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EvidenceList.ProtoReflect.Descriptor instead.
+func (*EvidenceList) Descriptor() ([]byte, []int) {
+	return file_sih_v1_ledger_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *EvidenceList) GetEvidence() []*EvidenceDocument {
+	if x != nil {
+		return x.Evidence
+	}
+	return nil
+}
+
+type ListAuditsByTargetRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	TargetId      string                 `protobuf:"bytes,1,opt,name=target_id,json=targetId,proto3" json:"target_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListAuditsByTargetRequest) Reset() {
+	*x = ListAuditsByTargetRequest{}
+	mi := &file_sih_v1_ledger_proto_msgTypes[17]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListAuditsByTargetRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListAuditsByTargetRequest) ProtoMessage() {}
+
+func (x *ListAuditsByTargetRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_sih_v1_ledger_proto_msgTypes[17]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListAuditsByTargetRequest.ProtoReflect.Descriptor instead.
+func (*ListAuditsByTargetRequest) Descriptor() ([]byte, []int) {
+	return file_sih_v1_ledger_proto_rawDescGZIP(), []int{17}
+}
+
+func (x *ListAuditsByTargetRequest) GetTargetId() string {
+	if x != nil {
+		return x.TargetId
+	}
+	return ""
+}
+
+type AuditDocument struct {
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	DocType   string                 `protobuf:"bytes,1,opt,name=doc_type,json=docType,proto3" json:"doc_type,omitempty"`
+	AuditHash string                 `protobuf:"bytes,2,opt,name=audit_hash,json=auditHash,proto3" json:"audit_hash,omitempty"`
+	Actor     string                 `protobuf:"bytes,3,opt,name=actor,proto3" json:"actor,omitempty"`
+	Action    string                 `protobuf:"bytes,4,opt,name=action,proto3" json:"action,omitempty"`
+	TargetId  string                 `protobuf:"bytes,5,opt,name=target_id,json=targetId,proto3" json:"target_id,omitempty"`
+	Timestamp string                 `protobuf:"bytes,6,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	TxId      string                 `protobuf:"bytes,7,opt,name=tx_id,json=txId,proto3" json:"tx_id,omitempty"`
+	// MSP ID and certificate common name of the identity that signed the transaction
+	Submitter     string `protobuf:"bytes,8,opt,name=submitter,proto3" json:"submitter,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AuditDocument) Reset() {
+	*x = AuditDocument{}
+	mi := &file_sih_v1_ledger_proto_msgTypes[18]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AuditDocument) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AuditDocument) ProtoMessage() {}
+
+func (x *AuditDocument) ProtoReflect() protoreflect.Message {
+	mi := &file_sih_v1_ledger_proto_msgTypes[18]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AuditDocument.ProtoReflect.Descriptor instead.
+func (*AuditDocument) Descriptor() ([]byte, []int) {
+	return file_sih_v1_ledger_proto_rawDescGZIP(), []int{18}
+}
+
+func (x *AuditDocument) GetDocType() string {
+	if x != nil {
+		return x.DocType
+	}
+	return ""
+}
+
+func (x *AuditDocument) GetAuditHash() string {
+	if x != nil {
+		return x.AuditHash
+	}
+	return ""
+}
+
+func (x *AuditDocument) GetActor() string {
+	if x != nil {
+		return x.Actor
+	}
+	return ""
+}
+
+func (x *AuditDocument) GetAction() string {
+	if x != nil {
+		return x.Action
+	}
+	return ""
+}
+
+func (x *AuditDocument) GetTargetId() string {
+	if x != nil {
+		return x.TargetId
+	}
+	return ""
+}
+
+func (x *AuditDocument) GetTimestamp() string {
+	if x != nil {
+		return x.Timestamp
+	}
+	return ""
+}
+
+func (x *AuditDocument) GetTxId() string {
+	if x != nil {
+		return x.TxId
+	}
+	return ""
+}
+
+func (x *AuditDocument) GetSubmitter() string {
+	if x != nil {
+		return x.Submitter
+	}
+	return ""
+}
+
+type AuditList struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Audits        []*AuditDocument       `protobuf:"bytes,1,rep,name=audits,proto3" json:"audits,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AuditList) Reset() {
+	*x = AuditList{}
+	mi := &file_sih_v1_ledger_proto_msgTypes[19]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AuditList) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AuditList) ProtoMessage() {}
+
+func (x *AuditList) ProtoReflect() protoreflect.Message {
+	mi := &file_sih_v1_ledger_proto_msgTypes[19]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AuditList.ProtoReflect.Descriptor instead.
+func (*AuditList) Descriptor() ([]byte, []int) {
+	return file_sih_v1_ledger_proto_rawDescGZIP(), []int{19}
+}
+
+func (x *AuditList) GetAudits() []*AuditDocument {
+	if x != nil {
+		return x.Audits
+	}
+	return nil
+}
+
+type SearchAuditsRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Actor string                 `protobuf:"bytes,1,opt,name=actor,proto3" json:"actor,omitempty"`
+	// Upper-case action name such as UPDATE_INCIDENT.
+	Action string `protobuf:"bytes,2,opt,name=action,proto3" json:"action,omitempty"`
+	// RFC3339 bounds on timestamp; from is inclusive and to exclusive.
+	From string `protobuf:"bytes,3,opt,name=from,proto3" json:"from,omitempty"`
+	To   string `protobuf:"bytes,4,opt,name=to,proto3" json:"to,omitempty"`
+	// Defaults to 20, at most 100.
+	PageSize int32 `protobuf:"varint,5,opt,name=page_size,json=pageSize,proto3" json:"page_size,omitempty"`
+	// Bookmark from the previous page.
+	Bookmark      string `protobuf:"bytes,6,opt,name=bookmark,proto3" json:"bookmark,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SearchAuditsRequest) Reset() {
+	*x = SearchAuditsRequest{}
+	mi := &file_sih_v1_ledger_proto_msgTypes[20]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SearchAuditsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SearchAuditsRequest) ProtoMessage() {}
+
+func (x *SearchAuditsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_sih_v1_ledger_proto_msgTypes[20]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SearchAuditsRequest.ProtoReflect.Descriptor instead.
+func (*SearchAuditsRequest) Descriptor() ([]byte, []int) {
+	return file_sih_v1_ledger_proto_rawDescGZIP(), []int{20}
+}
+
+func (x *SearchAuditsRequest) GetActor() string {
+	if x != nil {
+		return x.Actor
+	}
+	return ""
+}
+
+func (x *SearchAuditsRequest) GetAction() string {
+	if x != nil {
+		return x.Action
+	}
+	return ""
+}
+
+func (x *SearchAuditsRequest) GetFrom() string {
+	if x != nil {
+		return x.From
+	}
+	return ""
+}
+
+func (x *SearchAuditsRequest) GetTo() string {
+	if x != nil {
+		return x.To
+	}
+	return ""
+}
+
+func (x *SearchAuditsRequest) GetPageSize() int32 {
+	if x != nil {
+		return x.PageSize
+	}
+	return 0
+}
+
+func (x *SearchAuditsRequest) GetBookmark() string {
+	if x != nil {
+		return x.Bookmark
+	}
+	return ""
+}
+
+type AuditPage struct {
+	state  protoimpl.MessageState `protogen:"open.v1"`
+	Audits []*AuditDocument       `protobuf:"bytes,1,rep,name=audits,proto3" json:"audits,omitempty"`
+	// Empty when there are no more results.
+	Bookmark      string `protobuf:"bytes,2,opt,name=bookmark,proto3" json:"bookmark,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AuditPage) Reset() {
+	*x = AuditPage{}
+	mi := &file_sih_v1_ledger_proto_msgTypes[21]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AuditPage) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AuditPage) ProtoMessage() {}
+
+func (x *AuditPage) ProtoReflect() protoreflect.Message {
+	mi := &file_sih_v1_ledger_proto_msgTypes[21]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AuditPage.ProtoReflect.Descriptor instead.
+func (*AuditPage) Descriptor() ([]byte, []int) {
+	return file_sih_v1_ledger_proto_rawDescGZIP(), []int{21}
+}
+
+func (x *AuditPage) GetAudits() []*AuditDocument {
+	if x != nil {
+		return x.Audits
+	}
+	return nil
+}
+
+func (x *AuditPage) GetBookmark() string {
+	if x != nil {
+		return x.Bookmark
+	}
+	return ""
+}
+
+var File_sih_v1_ledger_proto protoreflect.FileDescriptor
+
+const file_sih_v1_ledger_proto_rawDesc = "" +
+	"\n" +
+	"\x13sih/v1/ledger.proto\x12\x06sih.v1\"L\n" +
+	"\x12TransactionReceipt\x12\x13\n" +
+	"\x05tx_id\x18\x01 \x01(\tR\x04txId\x12!\n" +
+	"\fblock_number\x18\x02 \x01(\x04R\vblockNumber\"$\n" +
+	"\x12GetDocumentRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\"=\n" +
+	"\x15DeleteDocumentRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x14\n" +
+	"\x05actor\x18\x02 \x01(\tR\x05actor\"\x8b\x01\n" +
+	"\x10CreateDIDRequest\x12\x1d\n" +
+	"\n" +
+	"digital_id\x18\x01 \x01(\tR\tdigitalId\x12!\n" +
+	"\fconsent_hash\x18\x02 \x01(\tR\vconsentHash\x12\x1d\n" +
+	"\n" +
+	"expires_at\x18\x03 \x01(\tR\texpiresAt\x12\x16\n" +
+	"\x06issuer\x18\x04 \x01(\tR\x06issuer\"\x8d\x01\n" +
+	"\x10UpdateDIDRequest\x12\x1d\n" +
+	"\n" +
+	"digital_id\x18\x01 \x01(\tR\tdigitalId\x12!\n" +
+	"\fconsent_hash\x18\x02 \x01(\tR\vconsentHash\x12\x1d\n" +
+	"\n" +
+	"expires_at\x18\x03 \x01(\tR\texpiresAt\x12\x18\n" +
+	"\aupdater\x18\x04 \x01(\tR\aupdater\"\xd3\x01\n" +
+	"\vDIDDocument\x12\x19\n" +
+	"\bdoc_type\x18\x01 \x01(\tR\adocType\x12\x1d\n" +
+	"\n" +
+	"digital_id\x18\x02 \x01(\tR\tdigitalId\x12!\n" +
+	"\fconsent_hash\x18\x03 \x01(\tR\vconsentHash\x12\x1b\n" +
+	"\tissued_at\x18\x04 \x01(\tR\bissuedAt\x12\x1d\n" +
+	"\n" +
+	"expires_at\x18\x05 \x01(\tR\texpiresAt\x12\x16\n" +
+	"\x06issuer\x18\x06 \x01(\tR\x06issuer\x12\x13\n" +
+	"\x05tx_id\x18\a \x01(\tR\x04txId\"\xb4\x02\n" +
+	"\x0fDIDVerification\x12\x1d\n" +
+	"\n" +
+	"digital_id\x18\x01 \x01(\tR\tdigitalId\x12\x14\n" +
+	"\x05valid\x18\x02 \x01(\bR\x05valid\x12\x16\n" +
+	"\x06exists\x18\x03 \x01(\bR\x06exists\x12\x18\n" +
+	"\aexpired\x18\x04 \x01(\bR\aexpired\x12\x18\n" +
+	"\arevoked\x18\x05 \x01(\bR\arevoked\x12\x16\n" +
+	"\x06reason\x18\x06 \x01(\tR\x06reason\x12\x16\n" +
+	"\x06issuer\x18\a \x01(\tR\x06issuer\x12\x1b\n" +
+	"\tissued_at\x18\b \x01(\tR\bissuedAt\x12\x1d\n" +
+	"\n" +
+	"expires_at\x18\t \x01(\tR\texpiresAt\x12\x1f\n" +
+	"\vverified_at\x18\n" +
+	" \x01(\tR\n" +
+	"verifiedAt\x12\x13\n" +
+	"\x05tx_id\x18\v \x01(\tR\x04txId\"\xa4\x01\n" +
+	"\x15CreateIncidentRequest\x12\x1f\n" +
+	"\vincident_id\x18\x01 \x01(\tR\n" +
+	"incidentId\x122\n" +
+	"\x15incident_summary_hash\x18\x02 \x01(\tR\x13incidentSummaryHash\x12\x1a\n" +
+	"\breporter\x18\x03 \x01(\tR\breporter\x12\x1a\n" +
+	"\bseverity\x18\x04 \x01(\tR\bseverity\"\x86\x01\n" +
+	"\x15UpdateIncidentRequest\x12\x1f\n" +
+	"\vincident_id\x18\x01 \x01(\tR\n" +
+	"incidentId\x122\n" +
+	"\x15incident_summary_hash\x18\x02 \x01(\tR\x13incidentSummaryHash\x12\x18\n" +
+	"\aupdater\x18\x03 \x01(\tR\aupdater\"\x86\x02\n" +
+	"\x10IncidentDocument\x12\x19\n" +
+	"\bdoc_type\x18\x01 \x01(\tR\adocType\x12\x1f\n" +
+	"\vincident_id\x18\x02 \x01(\tR\n" +
+	"incidentId\x122\n" +
+	"\x15incident_summary_hash\x18\x03 \x01(\tR\x13incidentSummaryHash\x12\x1d\n" +
+	"\n" +
+	"created_at\x18\x04 \x01(\tR\tcreatedAt\x12\x1a\n" +
+	"\breporter\x18\x05 \x01(\tR\breporter\x12\x13\n" +
+	"\x05tx_id\x18\x06 \x01(\tR\x04txId\x12\x16\n" +
+	"\x06status\x18\a \x01(\tR\x06status\x12\x1a\n" +
+	"\bseverity\x18\b \x01(\tR\bseverity\"\xc5\x01\n" +
+	"\x16SearchIncidentsRequest\x12\x12\n" +
+	"\x04from\x18\x01 \x01(\tR\x04from\x12\x0e\n" +
+	"\x02to\x18\x02 \x01(\tR\x02to\x12\x16\n" +
+	"\x06status\x18\x03 \x01(\tR\x06status\x12\x1a\n" +
+	"\bseverity\x18\x04 \x01(\tR\bseverity\x12\x1a\n" +
+	"\breporter\x18\x05 \x01(\tR\breporter\x12\x1b\n" +
+	"\tpage_size\x18\x06 \x01(\x05R\bpageSize\x12\x1a\n" +
+	"\bbookmark\x18\a \x01(\tR\bbookmark\"b\n" +
+	"\fIncidentPage\x126\n" +
+	"\tincidents\x18\x01 \x03(\v2\x18.sih.v1.IncidentDocumentR\tincidents\x12\x1a\n" +
+	"\bbookmark\x18\x02 \x01(\tR\bbookmark\"\xbe\x01\n" +
+	"\x15CreateEvidenceRequest\x12\x1f\n" +
+	"\vevidence_id\x18\x01 \x01(\tR\n" +
+	"evidenceId\x12#\n" +
+	"\revidence_hash\x18\x02 \x01(\tR\fevidenceHash\x12\x1f\n" +
+	"\vincident_id\x18\x03 \x01(\tR\n" +
+	"incidentId\x12\x1d\n" +
+	"\n" +
+	"media_type\x18\x04 \x01(\tR\tmediaType\x12\x1f\n" +
+	"\vuploaded_by\x18\x05 \x01(\tR\n" +
+	"uploadedBy\"\x96\x01\n" +
+	"\x15UpdateEvidenceRequest\x12\x1f\n" +
+	"\vevidence_id\x18\x01 \x01(\tR\n" +
+	"evidenceId\x12#\n" +
+	"\revidence_hash\x18\x02 \x01(\tR\fevidenceHash\x12\x1d\n" +
+	"\n" +
+	"media_type\x18\x03 \x01(\tR\tmediaType\x12\x18\n" +
+	"\aupdater\x18\x04 \x01(\tR\aupdater\"\x9a\x02\n" +
+	"\x10EvidenceDocument\x12\x19\n" +
+	"\bdoc_type\x18\x01 \x01(\tR\adocType\x12\x1f\n" +
+	"\vevidence_id\x18\x02 \x01(\tR\n" +
+	"evidenceId\x12#\n" +
+	"\revidence_hash\x18\x03 \x01(\tR\fevidenceHash\x12\x1f\n" +
+	"\vincident_id\x18\x04 \x01(\tR\n" +
+	"incidentId\x12\x1d\n" +
+	"\n" +
+	"media_type\x18\x05 \x01(\tR\tmediaType\x12\x1f\n" +
+	"\vuploaded_by\x18\x06 \x01(\tR\n" +
+	"uploadedBy\x12\x1d\n" +
+	"\n" +
+	"created_at\x18\a \x01(\tR\tcreatedAt\x12\x10\n" +
+	"\x03cid\x18\b \x01(\tR\x03cid\x12\x13\n" +
+	"\x05tx_id\x18\t \x01(\tR\x04txId\"@\n" +
+	"\x1dListEvidenceByIncidentRequest\x12\x1f\n" +
+	"\vincident_id\x18\x01 \x01(\tR\n" +
+	"incidentId\"D\n" +
+	"\fEvidenceList\x124\n" +
+	"\bevidence\x18\x01 \x03(\v2\x18.sih.v1.EvidenceDocumentR\bevidence\"8\n" +
+	"\x19ListAuditsByTargetRequest\x12\x1b\n" +
+	"\ttarget_id\x18\x01 \x01(\tR\btargetId\"\xe5\x01\n" +
+	"\rAuditDocument\x12\x19\n" +
+	"\bdoc_type\x18\x01 \x01(\tR\adocType\x12\x1d\n" +
+	"\n" +
+	"audit_hash\x18\x02 \x01(\tR\tauditHash\x12\x14\n" +
+	"\x05actor\x18\x03 \x01(\tR\x05actor\x12\x16\n" +
+	"\x06action\x18\x04 \x01(\tR\x06action\x12\x1b\n" +
+	"\ttarget_id\x18\x05 \x01(\tR\btargetId\x12\x1c\n" +
+	"\ttimestamp\x18\x06 \x01(\tR\ttimestamp\x12\x13\n" +
+	"\x05tx_id\x18\a \x01(\tR\x04txId\x12\x1c\n" +
+	"\tsubmitter\x18\b \x01(\tR\tsubmitter\":\n" +
+	"\tAuditList\x12-\n" +
+	"\x06audits\x18\x01 \x03(\v2\x15.sih.v1.AuditDocumentR\x06audits\"\xa0\x01\n" +
+	"\x13SearchAuditsRequest\x12\x14\n" +
+	"\x05actor\x18\x01 \x01(\tR\x05actor\x12\x16\n" +
+	"\x06action\x18\x02 \x01(\tR\x06action\x12\x12\n" +
+	"\x04from\x18\x03 \x01(\tR\x04from\x12\x0e\n" +
+	"\x02to\x18\x04 \x01(\tR\x02to\x12\x1b\n" +
+	"\tpage_size\x18\x05 \x01(\x05R\bpageSize\x12\x1a\n" +
+	"\bbookmark\x18\x06 \x01(\tR\bbookmark\"V\n" +
+	"\tAuditPage\x12-\n" +
+	"\x06audits\x18\x01 \x03(\v2\x15.sih.v1.AuditDocumentR\x06audits\x12\x1a\n" +
+	"\bbookmark\x18\x02 \x01(\tR\bbookmark2\xde\t\n" +
+	"\rLedgerService\x12A\n" +
+	"\tCreateDID\x12\x18.sih.v1.CreateDIDRequest\x1a\x1a.sih.v1.TransactionReceipt\x129\n" +
+	"\x06GetDID\x12\x1a.sih.v1.GetDocumentRequest\x1a\x13.sih.v1.DIDDocument\x12@\n" +
+	"\tVerifyDID\x12\x1a.sih.v1.GetDocumentRequest\x1a\x17.sih.v1.DIDVerification\x12A\n" +
+	"\tUpdateDID\x12\x18.sih.v1.UpdateDIDRequest\x1a\x1a.sih.v1.TransactionReceipt\x12F\n" +
+	"\tDeleteDID\x12\x1d.sih.v1.DeleteDocumentRequest\x1a\x1a.sih.v1.TransactionReceipt\x12K\n" +
+	"\x0eCreateIncident\x12\x1d.sih.v1.CreateIncidentRequest\x1a\x1a.sih.v1.TransactionReceipt\x12C\n" +
+	"\vGetIncident\x12\x1a.sih.v1.GetDocumentRequest\x1a\x18.sih.v1.IncidentDocument\x12K\n" +
+	"\x0eUpdateIncident\x12\x1d.sih.v1.UpdateIncidentRequest\x1a\x1a.sih.v1.TransactionReceipt\x12K\n" +
+	"\x0eDeleteIncident\x12\x1d.sih.v1.DeleteDocumentRequest\x1a\x1a.sih.v1.TransactionReceipt\x12G\n" +
+	"\x0fSearchIncidents\x12\x1e.sih.v1.SearchIncidentsRequest\x1a\x14.sih.v1.IncidentPage\x12K\n" +
+	"\x0eCreateEvidence\x12\x1d.sih.v1.CreateEvidenceRequest\x1a\x1a.sih.v1.TransactionReceipt\x12C\n" +
+	"\vGetEvidence\x12\x1a.sih.v1.GetDocumentRequest\x1a\x18.sih.v1.EvidenceDocument\x12K\n" +
+	"\x0eUpdateEvidence\x12\x1d.sih.v1.UpdateEvidenceRequest\x1a\x1a.sih.v1.TransactionReceipt\x12K\n" +
+	"\x0eDeleteEvidence\x12\x1d.sih.v1.DeleteDocumentRequest\x1a\x1a.sih.v1.TransactionReceipt\x12U\n" +
+	"\x16ListEvidenceByIncident\x12%.sih.v1.ListEvidenceByIncidentRequest\x1a\x14.sih.v1.EvidenceList\x12J\n" +
+	"\x12ListAuditsByTarget\x12!.sih.v1.ListAuditsByTargetRequest\x1a\x11.sih.v1.AuditList\x12>\n" +
+	"\fSearchAudits\x12\x1b.sih.v1.SearchAuditsRequest\x1a\x11.sih.v1.AuditPageB\"Z assetTransfer/proto/sih/v1;sihv1b\x06proto3"
+
+var (
+	file_sih_v1_ledger_proto_rawDescOnce sync.Once
+	file_sih_v1_ledger_proto_rawDescData []byte
+)
+
+func file_sih_v1_ledger_proto_rawDescGZIP() []byte {
+	file_sih_v1_ledger_proto_rawDescOnce.Do(func() {
+		file_sih_v1_ledger_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_sih_v1_ledger_proto_rawDesc), len(file_sih_v1_ledger_proto_rawDesc)))
+	})
+	return file_sih_v1_ledger_proto_rawDescData
+}
+
+var file_sih_v1_ledger_proto_msgTypes = make([]protoimpl.MessageInfo, 22)
+var file_sih_v1_ledger_proto_goTypes = []any{
+	(*TransactionReceipt)(nil),            // 0: sih.v1.TransactionReceipt
+	(*GetDocumentRequest)(nil),            // 1: sih.v1.GetDocumentRequest
+	(*DeleteDocumentRequest)(nil),         // 2: sih.v1.DeleteDocumentRequest
+	(*CreateDIDRequest)(nil),              // 3: sih.v1.CreateDIDRequest
+	(*UpdateDIDRequest)(nil),              // 4: sih.v1.UpdateDIDRequest
+	(*DIDDocument)(nil),                   // 5: sih.v1.DIDDocument
+	(*DIDVerification)(nil),               // 6: sih.v1.DIDVerification
+	(*CreateIncidentRequest)(nil),         // 7: sih.v1.CreateIncidentRequest
+	(*UpdateIncidentRequest)(nil),         // 8: sih.v1.UpdateIncidentRequest
+	(*IncidentDocument)(nil),              // 9: sih.v1.IncidentDocument
+	(*SearchIncidentsRequest)(nil),        // 10: sih.v1.SearchIncidentsRequest
+	(*IncidentPage)(nil),                  // 11: sih.v1.IncidentPage
+	(*CreateEvidenceRequest)(nil),         // 12: sih.v1.CreateEvidenceRequest
+	(*UpdateEvidenceRequest)(nil),         // 13: sih.v1.UpdateEvidenceRequest
+	(*EvidenceDocument)(nil),              // 14: sih.v1.EvidenceDocument
+	(*ListEvidenceByIncidentRequest)(nil), // 15: sih.v1.ListEvidenceByIncidentRequest
+	(*EvidenceList)(nil),                  // 16: sih.v1.EvidenceList
+	(*ListAuditsByTargetRequest)(nil),     // 17: sih.v1.ListAuditsByTargetRequest
+	(*AuditDocument)(nil),                 // 18: sih.v1.AuditDocument
+	(*AuditList)(nil),                     // 19: sih.v1.AuditList
+	(*SearchAuditsRequest)(nil),           // 20: sih.v1.SearchAuditsRequest
+	(*AuditPage)(nil),                     // 21: sih.v1.AuditPage
+}
+var file_sih_v1_ledger_proto_depIdxs = []int32{
+	9,  // 0: sih.v1.IncidentPage.incidents:type_name -> sih.v1.IncidentDocument
+	14, // 1: sih.v1.EvidenceList.evidence:type_name -> sih.v1.EvidenceDocument
+	18, // 2: sih.v1.AuditList.audits:type_name -> sih.v1.AuditDocument
+	18, // 3: sih.v1.AuditPage.audits:type_name -> sih.v1.AuditDocument
+	3,  // 4: sih.v1.LedgerService.CreateDID:input_type -> sih.v1.CreateDIDRequest
+	1,  // 5: sih.v1.LedgerService.GetDID:input_type -> sih.v1.GetDocumentRequest
+	1,  // 6: sih.v1.LedgerService.VerifyDID:input_type -> sih.v1.GetDocumentRequest
+	4,  // 7: sih.v1.LedgerService.UpdateDID:input_type -> sih.v1.UpdateDIDRequest
+	2,  // 8: sih.v1.LedgerService.DeleteDID:input_type -> sih.v1.DeleteDocumentRequest
+	7,  // 9: sih.v1.LedgerService.CreateIncident:input_type -> sih.v1.CreateIncidentRequest
+	1,  // 10: sih.v1.LedgerService.GetIncident:input_type -> sih.v1.GetDocumentRequest
+	8,  // 11: sih.v1.LedgerService.UpdateIncident:input_type -> sih.v1.UpdateIncidentRequest
+	2,  // 12: sih.v1.LedgerService.DeleteIncident:input_type -> sih.v1.DeleteDocumentRequest
+	10, // 13: sih.v1.LedgerService.SearchIncidents:input_type -> sih.v1.SearchIncidentsRequest
+	12, // 14: sih.v1.LedgerService.CreateEvidence:input_type -> sih.v1.CreateEvidenceRequest
+	1,  // 15: sih.v1.LedgerService.GetEvidence:input_type -> sih.v1.GetDocumentRequest
+	13, // 16: sih.v1.LedgerService.UpdateEvidence:input_type -> sih.v1.UpdateEvidenceRequest
+	2,  // 17: sih.v1.LedgerService.DeleteEvidence:input_type -> sih.v1.DeleteDocumentRequest
+	15, // 18: sih.v1.LedgerService.ListEvidenceByIncident:input_type -> sih.v1.ListEvidenceByIncidentRequest
+	17, // 19: sih.v1.LedgerService.ListAuditsByTarget:input_type -> sih.v1.ListAuditsByTargetRequest
+	20, // 20: sih.v1.LedgerService.SearchAudits:input_type -> sih.v1.SearchAuditsRequest
+	0,  // 21: sih.v1.LedgerService.CreateDID:output_type -> sih.v1.TransactionReceipt
+	5,  // 22: sih.v1.LedgerService.GetDID:output_type -> sih.v1.DIDDocument
+	6,  // 23: sih.v1.LedgerService.VerifyDID:output_type -> sih.v1.DIDVerification
+	0,  // 24: sih.v1.LedgerService.UpdateDID:output_type -> sih.v1.TransactionReceipt
+	0,  // 25: sih.v1.LedgerService.DeleteDID:output_type -> sih.v1.TransactionReceipt
+	0,  // 26: sih.v1.LedgerService.CreateIncident:output_type -> sih.v1.TransactionReceipt
+	9,  // 27: sih.v1.LedgerService.GetIncident:output_type -> sih.v1.IncidentDocument
+	0,  // 28: sih.v1.LedgerService.UpdateIncident:output_type -> sih.v1.TransactionReceipt
+	0,  // 29: sih.v1.LedgerService.DeleteIncident:output_type -> sih.v1.TransactionReceipt
+	11, // 30: sih.v1.LedgerService.SearchIncidents:output_type -> sih.v1.IncidentPage
+	0,  // 31: sih.v1.LedgerService.CreateEvidence:output_type -> sih.v1.TransactionReceipt
+	14, // 32: sih.v1.LedgerService.GetEvidence:output_type -> sih.v1.EvidenceDocument
+	0,  // 33: sih.v1.LedgerService.UpdateEvidence:output_type -> sih.v1.TransactionReceipt
+	0,  // 34: sih.v1.LedgerService.DeleteEvidence:output_type -> sih.v1.TransactionReceipt
+	16, // 35: sih.v1.LedgerService.ListEvidenceByIncident:output_type -> sih.v1.EvidenceList
+	19, // 36: sih.v1.LedgerService.ListAuditsByTarget:output_type -> sih.v1.AuditList
+	21, // 37: sih.v1.LedgerService.SearchAudits:output_type -> sih.v1.AuditPage
+	21, // [21:38] is the sub-list for method output_type
+	4,  // [4:21] is the sub-list for method input_type
+	4,  // [4:4] is the sub-list for extension type_name
+	4,  // [4:4] is the sub-list for extension extendee
+	0,  // [0:4] is the sub-list for field type_name
+}
+
+func init() { file_sih_v1_ledger_proto_init() }
+func file_sih_v1_ledger_proto_init() {
+	if File_sih_v1_ledger_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_sih_v1_ledger_proto_rawDesc), len(file_sih_v1_ledger_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   22,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_sih_v1_ledger_proto_goTypes,
+		DependencyIndexes: file_sih_v1_ledger_proto_depIdxs,
+		MessageInfos:      file_sih_v1_ledger_proto_msgTypes,
+	}.Build()
+	File_sih_v1_ledger_proto = out.File
+	file_sih_v1_ledger_proto_goTypes = nil
+	file_sih_v1_ledger_proto_depIdxs = nil
+}
@@ -0,0 +1,769 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: sih/v1/ledger.proto
+
+package sihv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	LedgerService_CreateDID_FullMethodName              = "/sih.v1.LedgerService/CreateDID"
+	LedgerService_GetDID_FullMethodName                 = "/sih.v1.LedgerService/GetDID"
+	LedgerService_VerifyDID_FullMethodName              = "/sih.v1.LedgerService/VerifyDID"
+	LedgerService_UpdateDID_FullMethodName              = "/sih.v1.LedgerService/UpdateDID"
+	LedgerService_DeleteDID_FullMethodName              = "/sih.v1.LedgerService/DeleteDID"
+	LedgerService_CreateIncident_FullMethodName         = "/sih.v1.LedgerService/CreateIncident"
+	LedgerService_GetIncident_FullMethodName            = "/sih.v1.LedgerService/GetIncident"
+	LedgerService_UpdateIncident_FullMethodName         = "/sih.v1.LedgerService/UpdateIncident"
+	LedgerService_DeleteIncident_FullMethodName         = "/sih.v1.LedgerService/DeleteIncident"
+	LedgerService_SearchIncidents_FullMethodName        = "/sih.v1.LedgerService/SearchIncidents"
+	LedgerService_CreateEvidence_FullMethodName         = "/sih.v1.LedgerService/CreateEvidence"
+	LedgerService_GetEvidence_FullMethodName            = "/sih.v1.LedgerService/GetEvidence"
+	LedgerService_UpdateEvidence_FullMethodName         = "/sih.v1.LedgerService/UpdateEvidence"
+	LedgerService_DeleteEvidence_FullMethodName         = "/sih.v1.LedgerService/DeleteEvidence"
+	LedgerService_ListEvidenceByIncident_FullMethodName = "/sih.v1.LedgerService/ListEvidenceByIncident"
+	LedgerService_ListAuditsByTarget_FullMethodName     = "/sih.v1.LedgerService/ListAuditsByTarget"
+	LedgerService_SearchAudits_FullMethodName           = "/sih.v1.LedgerService/SearchAudits"
+)
+
+// LedgerServiceClient is the client API for LedgerService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// LedgerService exposes the SIH chaincode operations over gRPC. It shares its
+// implementation with the REST API, so validation and error semantics match.
+type LedgerServiceClient interface {
+	// CreateDID anchors a new digital identity.
+	CreateDID(ctx context.Context, in *CreateDIDRequest, opts ...grpc.CallOption) (*TransactionReceipt, error)
+	// GetDID reads a digital identity by ID.
+	GetDID(ctx context.Context, in *GetDocumentRequest, opts ...grpc.CallOption) (*DIDDocument, error)
+	// VerifyDID reports whether a DID exists, is unexpired and has not been revoked.
+	VerifyDID(ctx context.Context, in *GetDocumentRequest, opts ...grpc.CallOption) (*DIDVerification, error)
+	// UpdateDID replaces the consent hash and expiry of a digital identity.
+	UpdateDID(ctx context.Context, in *UpdateDIDRequest, opts ...grpc.CallOption) (*TransactionReceipt, error)
+	// DeleteDID removes a digital identity.
+	DeleteDID(ctx context.Context, in *DeleteDocumentRequest, opts ...grpc.CallOption) (*TransactionReceipt, error)
+	// CreateIncident anchors a new incident report.
+	CreateIncident(ctx context.Context, in *CreateIncidentRequest, opts ...grpc.CallOption) (*TransactionReceipt, error)
+	// GetIncident reads an incident by ID.
+	GetIncident(ctx context.Context, in *GetDocumentRequest, opts ...grpc.CallOption) (*IncidentDocument, error)
+	// UpdateIncident replaces the summary hash of an incident.
+	UpdateIncident(ctx context.Context, in *UpdateIncidentRequest, opts ...grpc.CallOption) (*TransactionReceipt, error)
+	// DeleteIncident removes an incident.
+	DeleteIncident(ctx context.Context, in *DeleteDocumentRequest, opts ...grpc.CallOption) (*TransactionReceipt, error)
+	// SearchIncidents lists incidents by creation time, status, severity and reporter, newest first.
+	SearchIncidents(ctx context.Context, in *SearchIncidentsRequest, opts ...grpc.CallOption) (*IncidentPage, error)
+	// CreateEvidence anchors the hash of an evidence file against an incident.
+	CreateEvidence(ctx context.Context, in *CreateEvidenceRequest, opts ...grpc.CallOption) (*TransactionReceipt, error)
+	// GetEvidence reads an evidence record by ID.
+	GetEvidence(ctx context.Context, in *GetDocumentRequest, opts ...grpc.CallOption) (*EvidenceDocument, error)
+	// UpdateEvidence replaces the hash and media type of an evidence record.
+	UpdateEvidence(ctx context.Context, in *UpdateEvidenceRequest, opts ...grpc.CallOption) (*TransactionReceipt, error)
+	// DeleteEvidence removes an evidence record.
+	DeleteEvidence(ctx context.Context, in *DeleteDocumentRequest, opts ...grpc.CallOption) (*TransactionReceipt, error)
+	// ListEvidenceByIncident returns all evidence anchored against an incident.
+	ListEvidenceByIncident(ctx context.Context, in *ListEvidenceByIncidentRequest, opts ...grpc.CallOption) (*EvidenceList, error)
+	// ListAuditsByTarget returns the audit trail of a document.
+	ListAuditsByTarget(ctx context.Context, in *ListAuditsByTargetRequest, opts ...grpc.CallOption) (*AuditList, error)
+	// SearchAudits lists audit logs by actor, action and period, newest first.
+	SearchAudits(ctx context.Context, in *SearchAuditsRequest, opts ...grpc.CallOption) (*AuditPage, error)
+}
+
+type ledgerServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewLedgerServiceClient(cc grpc.ClientConnInterface) LedgerServiceClient {
+	return &ledgerServiceClient{cc}
+}
+
+func (c *ledgerServiceClient) CreateDID(ctx context.Context, in *CreateDIDRequest, opts ...grpc.CallOption) (*TransactionReceipt, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(TransactionReceipt)
+	err := c.cc.Invoke(ctx, LedgerService_CreateDID_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ledgerServiceClient) GetDID(ctx context.Context, in *GetDocumentRequest, opts ...grpc.CallOption) (*DIDDocument, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DIDDocument)
+	err := c.cc.Invoke(ctx, LedgerService_GetDID_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ledgerServiceClient) VerifyDID(ctx context.Context, in *GetDocumentRequest, opts ...grpc.CallOption) (*DIDVerification, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DIDVerification)
+	err := c.cc.Invoke(ctx, LedgerService_VerifyDID_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ledgerServiceClient) UpdateDID(ctx context.Context, in *UpdateDIDRequest, opts ...grpc.CallOption) (*TransactionReceipt, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(TransactionReceipt)
+	err := c.cc.Invoke(ctx, LedgerService_UpdateDID_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ledgerServiceClient) DeleteDID(ctx context.Context, in *DeleteDocumentRequest, opts ...grpc.CallOption) (*TransactionReceipt, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(TransactionReceipt)
+	err := c.cc.Invoke(ctx, LedgerService_DeleteDID_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ledgerServiceClient) CreateIncident(ctx context.Context, in *CreateIncidentRequest, opts ...grpc.CallOption) (*TransactionReceipt, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(TransactionReceipt)
+	err := c.cc.Invoke(ctx, LedgerService_CreateIncident_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ledgerServiceClient) GetIncident(ctx context.Context, in *GetDocumentRequest, opts ...grpc.CallOption) (*IncidentDocument, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(IncidentDocument)
+	err := c.cc.Invoke(ctx, LedgerService_GetIncident_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ledgerServiceClient) UpdateIncident(ctx context.Context, in *UpdateIncidentRequest, opts ...grpc.CallOption) (*TransactionReceipt, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(TransactionReceipt)
+	err := c.cc.Invoke(ctx, LedgerService_UpdateIncident_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ledgerServiceClient) DeleteIncident(ctx context.Context, in *DeleteDocumentRequest, opts ...grpc.CallOption) (*TransactionReceipt, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(TransactionReceipt)
+	err := c.cc.Invoke(ctx, LedgerService_DeleteIncident_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ledgerServiceClient) SearchIncidents(ctx context.Context, in *SearchIncidentsRequest, opts ...grpc.CallOption) (*IncidentPage, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(IncidentPage)
+	err := c.cc.Invoke(ctx, LedgerService_SearchIncidents_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ledgerServiceClient) CreateEvidence(ctx context.Context, in *CreateEvidenceRequest, opts ...grpc.CallOption) (*TransactionReceipt, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(TransactionReceipt)
+	err := c.cc.Invoke(ctx, LedgerService_CreateEvidence_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ledgerServiceClient) GetEvidence(ctx context.Context, in *GetDocumentRequest, opts ...grpc.CallOption) (*EvidenceDocument, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(EvidenceDocument)
+	err := c.cc.Invoke(ctx, LedgerService_GetEvidence_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ledgerServiceClient) UpdateEvidence(ctx context.Context, in *UpdateEvidenceRequest, opts ...grpc.CallOption) (*TransactionReceipt, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(TransactionReceipt)
+	err := c.cc.Invoke(ctx, LedgerService_UpdateEvidence_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ledgerServiceClient) DeleteEvidence(ctx context.Context, in *DeleteDocumentRequest, opts ...grpc.CallOption) (*TransactionReceipt, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(TransactionReceipt)
+	err := c.cc.Invoke(ctx, LedgerService_DeleteEvidence_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ledgerServiceClient) ListEvidenceByIncident(ctx context.Context, in *ListEvidenceByIncidentRequest, opts ...grpc.CallOption) (*EvidenceList, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(EvidenceList)
+	err := c.cc.Invoke(ctx, LedgerService_ListEvidenceByIncident_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ledgerServiceClient) ListAuditsByTarget(ctx context.Context, in *ListAuditsByTargetRequest, opts ...grpc.CallOption) (*AuditList, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(AuditList)
+	err := c.cc.Invoke(ctx, LedgerService_ListAuditsByTarget_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ledgerServiceClient) SearchAudits(ctx context.Context, in *SearchAuditsRequest, opts ...grpc.CallOption) (*AuditPage, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(AuditPage)
+	err := c.cc.Invoke(ctx, LedgerService_SearchAudits_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// LedgerServiceServer is the server API for LedgerService service.
+// All implementations must embed UnimplementedLedgerServiceServer
+// for forward compatibility.
+//
+// LedgerService exposes the SIH chaincode operations over gRPC. It shares its
+// implementation with the REST API, so validation and error semantics match.
+type LedgerServiceServer interface {
+	// CreateDID anchors a new digital identity.
+	CreateDID(context.Context, *CreateDIDRequest) (*TransactionReceipt, error)
+	// GetDID reads a digital identity by ID.
+	GetDID(context.Context, *GetDocumentRequest) (*DIDDocument, error)
+	// VerifyDID reports whether a DID exists, is unexpired and has not been revoked.
+	VerifyDID(context.Context, *GetDocumentRequest) (*DIDVerification, error)
+	// UpdateDID replaces the consent hash and expiry of a digital identity.
+	UpdateDID(context.Context, *UpdateDIDRequest) (*TransactionReceipt, error)
+	// DeleteDID removes a digital identity.
+	DeleteDID(context.Context, *DeleteDocumentRequest) (*TransactionReceipt, error)
+	// CreateIncident anchors a new incident report.
+	CreateIncident(context.Context, *CreateIncidentRequest) (*TransactionReceipt, error)
+	// GetIncident reads an incident by ID.
+	GetIncident(context.Context, *GetDocumentRequest) (*IncidentDocument, error)
+	// UpdateIncident replaces the summary hash of an incident.
+	UpdateIncident(context.Context, *UpdateIncidentRequest) (*TransactionReceipt, error)
+	// DeleteIncident removes an incident.
+	DeleteIncident(context.Context, *DeleteDocumentRequest) (*TransactionReceipt, error)
+	// SearchIncidents lists incidents by creation time, status, severity and reporter, newest first.
+	SearchIncidents(context.Context, *SearchIncidentsRequest) (*IncidentPage, error)
+	// CreateEvidence anchors the hash of an evidence file against an incident.
+	CreateEvidence(context.Context, *CreateEvidenceRequest) (*TransactionReceipt, error)
+	// GetEvidence reads an evidence record by ID.
+	GetEvidence(context.Context, *GetDocumentRequest) (*EvidenceDocument, error)
+	// UpdateEvidence replaces the hash and media type of an evidence record.
+	UpdateEvidence(context.Context, *UpdateEvidenceRequest) (*TransactionReceipt, error)
+	// DeleteEvidence removes an evidence record.
+	DeleteEvidence(context.Context, *DeleteDocumentRequest) (*TransactionReceipt, error)
+	// ListEvidenceByIncident returns all evidence anchored against an incident.
+	ListEvidenceByIncident(context.Context, *ListEvidenceByIncidentRequest) (*EvidenceList, error)
+	// ListAuditsByTarget returns the audit trail of a document.
+	ListAuditsByTarget(context.Context, *ListAuditsByTargetRequest) (*AuditList, error)
+	// SearchAudits lists audit logs by actor, action and period, newest first.
+	SearchAudits(context.Context, *SearchAuditsRequest) (*AuditPage, error)
+	mustEmbedUnimplementedLedgerServiceServer()
+}
+
+// UnimplementedLedgerServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedLedgerServiceServer struct{}
+
+func (UnimplementedLedgerServiceServer) CreateDID(context.Context, *CreateDIDRequest) (*TransactionReceipt, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateDID not implemented")
+}
+func (UnimplementedLedgerServiceServer) GetDID(context.Context, *GetDocumentRequest) (*DIDDocument, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetDID not implemented")
+}
+func (UnimplementedLedgerServiceServer) VerifyDID(context.Context, *GetDocumentRequest) (*DIDVerification, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method VerifyDID not implemented")
+}
+func (UnimplementedLedgerServiceServer) UpdateDID(context.Context, *UpdateDIDRequest) (*TransactionReceipt, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateDID not implemented")
+}
+func (UnimplementedLedgerServiceServer) DeleteDID(context.Context, *DeleteDocumentRequest) (*TransactionReceipt, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteDID not implemented")
+}
+func (UnimplementedLedgerServiceServer) CreateIncident(context.Context, *CreateIncidentRequest) (*TransactionReceipt, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateIncident not implemented")
+}
+func (UnimplementedLedgerServiceServer) GetIncident(context.Context, *GetDocumentRequest) (*IncidentDocument, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetIncident not implemented")
+}
+func (UnimplementedLedgerServiceServer) UpdateIncident(context.Context, *UpdateIncidentRequest) (*TransactionReceipt, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateIncident not implemented")
+}
+func (UnimplementedLedgerServiceServer) DeleteIncident(context.Context, *DeleteDocumentRequest) (*TransactionReceipt, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteIncident not implemented")
+}
+func (UnimplementedLedgerServiceServer) SearchIncidents(context.Context, *SearchIncidentsRequest) (*IncidentPage, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SearchIncidents not implemented")
+}
+func (UnimplementedLedgerServiceServer) CreateEvidence(context.Context, *CreateEvidenceRequest) (*TransactionReceipt, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateEvidence not implemented")
+}
+func (UnimplementedLedgerServiceServer) GetEvidence(context.Context, *GetDocumentRequest) (*EvidenceDocument, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetEvidence not implemented")
+}
+func (UnimplementedLedgerServiceServer) UpdateEvidence(context.Context, *UpdateEvidenceRequest) (*TransactionReceipt, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateEvidence not implemented")
+}
+func (UnimplementedLedgerServiceServer) DeleteEvidence(context.Context, *DeleteDocumentRequest) (*TransactionReceipt, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteEvidence not implemented")
+}
+func (UnimplementedLedgerServiceServer) ListEvidenceByIncident(context.Context, *ListEvidenceByIncidentRequest) (*EvidenceList, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListEvidenceByIncident not implemented")
+}
+func (UnimplementedLedgerServiceServer) ListAuditsByTarget(context.Context, *ListAuditsByTargetRequest) (*AuditList, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListAuditsByTarget not implemented")
+}
+func (UnimplementedLedgerServiceServer) SearchAudits(context.Context, *SearchAuditsRequest) (*AuditPage, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SearchAudits not implemented")
+}
+func (UnimplementedLedgerServiceServer) mustEmbedUnimplementedLedgerServiceServer() {}
+func (UnimplementedLedgerServiceServer) testEmbeddedByValue()                       {}
+
+// UnsafeLedgerServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to LedgerServiceServer will
+// result in compilation errors.
+type UnsafeLedgerServiceServer interface {
+	mustEmbedUnimplementedLedgerServiceServer()
+}
+
+func RegisterLedgerServiceServer(s grpc.ServiceRegistrar, srv LedgerServiceServer) {
+	// If the following call pancis, it indicates UnimplementedLedgerServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&LedgerService_ServiceDesc, srv)
+}
+
+func _LedgerService_CreateDID_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateDIDRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LedgerServiceServer).CreateDID(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LedgerService_CreateDID_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LedgerServiceServer).CreateDID(ctx, req.(*CreateDIDRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LedgerService_GetDID_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetDocumentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LedgerServiceServer).GetDID(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LedgerService_GetDID_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LedgerServiceServer).GetDID(ctx, req.(*GetDocumentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LedgerService_VerifyDID_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetDocumentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LedgerServiceServer).VerifyDID(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LedgerService_VerifyDID_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LedgerServiceServer).VerifyDID(ctx, req.(*GetDocumentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LedgerService_UpdateDID_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateDIDRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LedgerServiceServer).UpdateDID(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LedgerService_UpdateDID_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LedgerServiceServer).UpdateDID(ctx, req.(*UpdateDIDRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LedgerService_DeleteDID_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteDocumentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LedgerServiceServer).DeleteDID(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LedgerService_DeleteDID_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LedgerServiceServer).DeleteDID(ctx, req.(*DeleteDocumentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LedgerService_CreateIncident_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateIncidentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LedgerServiceServer).CreateIncident(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LedgerService_CreateIncident_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LedgerServiceServer).CreateIncident(ctx, req.(*CreateIncidentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LedgerService_GetIncident_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetDocumentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LedgerServiceServer).GetIncident(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LedgerService_GetIncident_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LedgerServiceServer).GetIncident(ctx, req.(*GetDocumentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LedgerService_UpdateIncident_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateIncidentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LedgerServiceServer).UpdateIncident(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LedgerService_UpdateIncident_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LedgerServiceServer).UpdateIncident(ctx, req.(*UpdateIncidentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LedgerService_DeleteIncident_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteDocumentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LedgerServiceServer).DeleteIncident(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LedgerService_DeleteIncident_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LedgerServiceServer).DeleteIncident(ctx, req.(*DeleteDocumentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LedgerService_SearchIncidents_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SearchIncidentsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LedgerServiceServer).SearchIncidents(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LedgerService_SearchIncidents_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LedgerServiceServer).SearchIncidents(ctx, req.(*SearchIncidentsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LedgerService_CreateEvidence_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateEvidenceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LedgerServiceServer).CreateEvidence(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LedgerService_CreateEvidence_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LedgerServiceServer).CreateEvidence(ctx, req.(*CreateEvidenceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LedgerService_GetEvidence_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetDocumentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LedgerServiceServer).GetEvidence(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LedgerService_GetEvidence_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LedgerServiceServer).GetEvidence(ctx, req.(*GetDocumentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LedgerService_UpdateEvidence_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateEvidenceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LedgerServiceServer).UpdateEvidence(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LedgerService_UpdateEvidence_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LedgerServiceServer).UpdateEvidence(ctx, req.(*UpdateEvidenceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LedgerService_DeleteEvidence_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteDocumentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LedgerServiceServer).DeleteEvidence(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LedgerService_DeleteEvidence_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LedgerServiceServer).DeleteEvidence(ctx, req.(*DeleteDocumentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LedgerService_ListEvidenceByIncident_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListEvidenceByIncidentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LedgerServiceServer).ListEvidenceByIncident(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LedgerService_ListEvidenceByIncident_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LedgerServiceServer).ListEvidenceByIncident(ctx, req.(*ListEvidenceByIncidentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LedgerService_ListAuditsByTarget_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListAuditsByTargetRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LedgerServiceServer).ListAuditsByTarget(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LedgerService_ListAuditsByTarget_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LedgerServiceServer).ListAuditsByTarget(ctx, req.(*ListAuditsByTargetRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LedgerService_SearchAudits_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SearchAuditsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LedgerServiceServer).SearchAudits(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LedgerService_SearchAudits_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LedgerServiceServer).SearchAudits(ctx, req.(*SearchAuditsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// LedgerService_ServiceDesc is the grpc.ServiceDesc for LedgerService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var LedgerService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "sih.v1.LedgerService",
+	HandlerType: (*LedgerServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "CreateDID",
+			Handler:    _LedgerService_CreateDID_Handler,
+		},
+		{
+			MethodName: "GetDID",
+			Handler:    _LedgerService_GetDID_Handler,
+		},
+		{
+			MethodName: "VerifyDID",
+			Handler:    _LedgerService_VerifyDID_Handler,
+		},
+		{
+			MethodName: "UpdateDID",
+			Handler:    _LedgerService_UpdateDID_Handler,
+		},
+		{
+			MethodName: "DeleteDID",
+			Handler:    _LedgerService_DeleteDID_Handler,
+		},
+		{
+			MethodName: "CreateIncident",
+			Handler:    _LedgerService_CreateIncident_Handler,
+		},
+		{
+			MethodName: "GetIncident",
+			Handler:    _LedgerService_GetIncident_Handler,
+		},
+		{
+			MethodName: "UpdateIncident",
+			Handler:    _LedgerService_UpdateIncident_Handler,
+		},
+		{
+			MethodName: "DeleteIncident",
+			Handler:    _LedgerService_DeleteIncident_Handler,
+		},
+		{
+			MethodName: "SearchIncidents",
+			Handler:    _LedgerService_SearchIncidents_Handler,
+		},
+		{
+			MethodName: "CreateEvidence",
+			Handler:    _LedgerService_CreateEvidence_Handler,
+		},
+		{
+			MethodName: "GetEvidence",
+			Handler:    _LedgerService_GetEvidence_Handler,
+		},
+		{
+			MethodName: "UpdateEvidence",
+			Handler:    _LedgerService_UpdateEvidence_Handler,
+		},
+		{
+			MethodName: "DeleteEvidence",
+			Handler:    _LedgerService_DeleteEvidence_Handler,
+		},
+		{
+			MethodName: "ListEvidenceByIncident",
+			Handler:    _LedgerService_ListEvidenceByIncident_Handler,
+		},
+		{
+			MethodName: "ListAuditsByTarget",
+			Handler:    _LedgerService_ListAuditsByTarget_Handler,
+		},
+		{
+			MethodName: "SearchAudits",
+			Handler:    _LedgerService_SearchAudits_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "sih/v1/ledger.proto",
+}
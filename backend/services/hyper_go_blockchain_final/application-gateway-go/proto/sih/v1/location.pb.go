@@ -0,0 +1,861 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.9
+// 	protoc        (unknown)
+// source: sih/v1/location.proto
+
+package sihv1
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type LocationStreamRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Message:
+	//
+	//	*LocationStreamRequest_Start
+	//	*LocationStreamRequest_Pings
+	//	*LocationStreamRequest_Heartbeat
+	Message       isLocationStreamRequest_Message `protobuf_oneof:"message"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LocationStreamRequest) Reset() {
+	*x = LocationStreamRequest{}
+	mi := &file_sih_v1_location_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LocationStreamRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LocationStreamRequest) ProtoMessage() {}
+
+func (x *LocationStreamRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_sih_v1_location_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LocationStreamRequest.ProtoReflect.Descriptor instead.
+func (*LocationStreamRequest) Descriptor() ([]byte, []int) {
+	return file_sih_v1_location_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *LocationStreamRequest) GetMessage() isLocationStreamRequest_Message {
+	if x != nil {
+		return x.Message
+	}
+	return nil
+}
+
+func (x *LocationStreamRequest) GetStart() *StartLocationStream {
+	if x != nil {
+		if x, ok := x.Message.(*LocationStreamRequest_Start); ok {
+			return x.Start
+		}
+	}
+	return nil
+}
+
+func (x *LocationStreamRequest) GetPings() *LocationPingBatch {
+	if x != nil {
+		if x, ok := x.Message.(*LocationStreamRequest_Pings); ok {
+			return x.Pings
+		}
+	}
+	return nil
+}
+
+func (x *LocationStreamRequest) GetHeartbeat() *LocationHeartbeat {
+	if x != nil {
+		if x, ok := x.Message.(*LocationStreamRequest_Heartbeat); ok {
+			return x.Heartbeat
+		}
+	}
+	return nil
+}
+
+type isLocationStreamRequest_Message interface {
+	isLocationStreamRequest_Message()
+}
+
+type LocationStreamRequest_Start struct {
+	// start must be the first message on a stream.
+	Start *StartLocationStream `protobuf:"bytes,1,opt,name=start,proto3,oneof"`
+}
+
+type LocationStreamRequest_Pings struct {
+	Pings *LocationPingBatch `protobuf:"bytes,2,opt,name=pings,proto3,oneof"`
+}
+
+type LocationStreamRequest_Heartbeat struct {
+	Heartbeat *LocationHeartbeat `protobuf:"bytes,3,opt,name=heartbeat,proto3,oneof"`
+}
+
+func (*LocationStreamRequest_Start) isLocationStreamRequest_Message() {}
+
+func (*LocationStreamRequest_Pings) isLocationStreamRequest_Message() {}
+
+func (*LocationStreamRequest_Heartbeat) isLocationStreamRequest_Message() {}
+
+// StartLocationStream names the tourist whose pings the stream carries.
+type StartLocationStream struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	DigitalId     string                 `protobuf:"bytes,1,opt,name=digital_id,json=digitalId,proto3" json:"digital_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StartLocationStream) Reset() {
+	*x = StartLocationStream{}
+	mi := &file_sih_v1_location_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StartLocationStream) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StartLocationStream) ProtoMessage() {}
+
+func (x *StartLocationStream) ProtoReflect() protoreflect.Message {
+	mi := &file_sih_v1_location_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StartLocationStream.ProtoReflect.Descriptor instead.
+func (*StartLocationStream) Descriptor() ([]byte, []int) {
+	return file_sih_v1_location_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *StartLocationStream) GetDigitalId() string {
+	if x != nil {
+		return x.DigitalId
+	}
+	return ""
+}
+
+// LocationPingBatch is the pings recorded since the previous batch, at most 500. The sequence is
+// chosen by the client and echoed in the acknowledgement.
+type LocationPingBatch struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Sequence      uint64                 `protobuf:"varint,1,opt,name=sequence,proto3" json:"sequence,omitempty"`
+	Pings         []*LocationPing        `protobuf:"bytes,2,rep,name=pings,proto3" json:"pings,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LocationPingBatch) Reset() {
+	*x = LocationPingBatch{}
+	mi := &file_sih_v1_location_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LocationPingBatch) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LocationPingBatch) ProtoMessage() {}
+
+func (x *LocationPingBatch) ProtoReflect() protoreflect.Message {
+	mi := &file_sih_v1_location_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LocationPingBatch.ProtoReflect.Descriptor instead.
+func (*LocationPingBatch) Descriptor() ([]byte, []int) {
+	return file_sih_v1_location_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *LocationPingBatch) GetSequence() uint64 {
+	if x != nil {
+		return x.Sequence
+	}
+	return 0
+}
+
+func (x *LocationPingBatch) GetPings() []*LocationPing {
+	if x != nil {
+		return x.Pings
+	}
+	return nil
+}
+
+type LocationPing struct {
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	Latitude  float64                `protobuf:"fixed64,1,opt,name=latitude,proto3" json:"latitude,omitempty"`
+	Longitude float64                `protobuf:"fixed64,2,opt,name=longitude,proto3" json:"longitude,omitempty"`
+	Accuracy  float64                `protobuf:"fixed64,3,opt,name=accuracy,proto3" json:"accuracy,omitempty"`
+	Speed     *float64               `protobuf:"fixed64,4,opt,name=speed,proto3,oneof" json:"speed,omitempty"`
+	Heading   *float64               `protobuf:"fixed64,5,opt,name=heading,proto3,oneof" json:"heading,omitempty"`
+	Battery   *int32                 `protobuf:"varint,6,opt,name=battery,proto3,oneof" json:"battery,omitempty"`
+	// recorded_at is an RFC 3339 time.
+	RecordedAt    string `protobuf:"bytes,7,opt,name=recorded_at,json=recordedAt,proto3" json:"recorded_at,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LocationPing) Reset() {
+	*x = LocationPing{}
+	mi := &file_sih_v1_location_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LocationPing) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LocationPing) ProtoMessage() {}
+
+func (x *LocationPing) ProtoReflect() protoreflect.Message {
+	mi := &file_sih_v1_location_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LocationPing.ProtoReflect.Descriptor instead.
+func (*LocationPing) Descriptor() ([]byte, []int) {
+	return file_sih_v1_location_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *LocationPing) GetLatitude() float64 {
+	if x != nil {
+		return x.Latitude
+	}
+	return 0
+}
+
+func (x *LocationPing) GetLongitude() float64 {
+	if x != nil {
+		return x.Longitude
+	}
+	return 0
+}
+
+func (x *LocationPing) GetAccuracy() float64 {
+	if x != nil {
+		return x.Accuracy
+	}
+	return 0
+}
+
+func (x *LocationPing) GetSpeed() float64 {
+	if x != nil && x.Speed != nil {
+		return *x.Speed
+	}
+	return 0
+}
+
+func (x *LocationPing) GetHeading() float64 {
+	if x != nil && x.Heading != nil {
+		return *x.Heading
+	}
+	return 0
+}
+
+func (x *LocationPing) GetBattery() int32 {
+	if x != nil && x.Battery != nil {
+		return *x.Battery
+	}
+	return 0
+}
+
+func (x *LocationPing) GetRecordedAt() string {
+	if x != nil {
+		return x.RecordedAt
+	}
+	return ""
+}
+
+// LocationHeartbeat keeps a stream without pings open. The gateway answers each client heartbeat and
+// sends its own when it has had nothing else to send for a heartbeat interval.
+type LocationHeartbeat struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	SentAt        string                 `protobuf:"bytes,1,opt,name=sent_at,json=sentAt,proto3" json:"sent_at,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LocationHeartbeat) Reset() {
+	*x = LocationHeartbeat{}
+	mi := &file_sih_v1_location_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LocationHeartbeat) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LocationHeartbeat) ProtoMessage() {}
+
+func (x *LocationHeartbeat) ProtoReflect() protoreflect.Message {
+	mi := &file_sih_v1_location_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LocationHeartbeat.ProtoReflect.Descriptor instead.
+func (*LocationHeartbeat) Descriptor() ([]byte, []int) {
+	return file_sih_v1_location_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *LocationHeartbeat) GetSentAt() string {
+	if x != nil {
+		return x.SentAt
+	}
+	return ""
+}
+
+type LocationStreamResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Message:
+	//
+	//	*LocationStreamResponse_Accepted
+	//	*LocationStreamResponse_Ack
+	//	*LocationStreamResponse_Alert
+	//	*LocationStreamResponse_Heartbeat
+	Message       isLocationStreamResponse_Message `protobuf_oneof:"message"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LocationStreamResponse) Reset() {
+	*x = LocationStreamResponse{}
+	mi := &file_sih_v1_location_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LocationStreamResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LocationStreamResponse) ProtoMessage() {}
+
+func (x *LocationStreamResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_sih_v1_location_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LocationStreamResponse.ProtoReflect.Descriptor instead.
+func (*LocationStreamResponse) Descriptor() ([]byte, []int) {
+	return file_sih_v1_location_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *LocationStreamResponse) GetMessage() isLocationStreamResponse_Message {
+	if x != nil {
+		return x.Message
+	}
+	return nil
+}
+
+func (x *LocationStreamResponse) GetAccepted() *LocationStreamAccepted {
+	if x != nil {
+		if x, ok := x.Message.(*LocationStreamResponse_Accepted); ok {
+			return x.Accepted
+		}
+	}
+	return nil
+}
+
+func (x *LocationStreamResponse) GetAck() *LocationPingAck {
+	if x != nil {
+		if x, ok := x.Message.(*LocationStreamResponse_Ack); ok {
+			return x.Ack
+		}
+	}
+	return nil
+}
+
+func (x *LocationStreamResponse) GetAlert() *ZoneAlert {
+	if x != nil {
+		if x, ok := x.Message.(*LocationStreamResponse_Alert); ok {
+			return x.Alert
+		}
+	}
+	return nil
+}
+
+func (x *LocationStreamResponse) GetHeartbeat() *LocationHeartbeat {
+	if x != nil {
+		if x, ok := x.Message.(*LocationStreamResponse_Heartbeat); ok {
+			return x.Heartbeat
+		}
+	}
+	return nil
+}
+
+type isLocationStreamResponse_Message interface {
+	isLocationStreamResponse_Message()
+}
+
+type LocationStreamResponse_Accepted struct {
+	Accepted *LocationStreamAccepted `protobuf:"bytes,1,opt,name=accepted,proto3,oneof"`
+}
+
+type LocationStreamResponse_Ack struct {
+	Ack *LocationPingAck `protobuf:"bytes,2,opt,name=ack,proto3,oneof"`
+}
+
+type LocationStreamResponse_Alert struct {
+	Alert *ZoneAlert `protobuf:"bytes,3,opt,name=alert,proto3,oneof"`
+}
+
+type LocationStreamResponse_Heartbeat struct {
+	Heartbeat *LocationHeartbeat `protobuf:"bytes,4,opt,name=heartbeat,proto3,oneof"`
+}
+
+func (*LocationStreamResponse_Accepted) isLocationStreamResponse_Message() {}
+
+func (*LocationStreamResponse_Ack) isLocationStreamResponse_Message() {}
+
+func (*LocationStreamResponse_Alert) isLocationStreamResponse_Message() {}
+
+func (*LocationStreamResponse_Heartbeat) isLocationStreamResponse_Message() {}
+
+// LocationStreamAccepted answers the start message with the stream's limits.
+type LocationStreamAccepted struct {
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	DigitalId string                 `protobuf:"bytes,1,opt,name=digital_id,json=digitalId,proto3" json:"digital_id,omitempty"`
+	// heartbeat_seconds is how often the client should send a heartbeat when it has no pings. A
+	// stream that sends nothing for three intervals is closed.
+	HeartbeatSeconds uint32 `protobuf:"varint,2,opt,name=heartbeat_seconds,json=heartbeatSeconds,proto3" json:"heartbeat_seconds,omitempty"`
+	// max_in_flight is how many batches the gateway queues before it stops reading the stream.
+	MaxInFlight   uint32 `protobuf:"varint,3,opt,name=max_in_flight,json=maxInFlight,proto3" json:"max_in_flight,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LocationStreamAccepted) Reset() {
+	*x = LocationStreamAccepted{}
+	mi := &file_sih_v1_location_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LocationStreamAccepted) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LocationStreamAccepted) ProtoMessage() {}
+
+func (x *LocationStreamAccepted) ProtoReflect() protoreflect.Message {
+	mi := &file_sih_v1_location_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LocationStreamAccepted.ProtoReflect.Descriptor instead.
+func (*LocationStreamAccepted) Descriptor() ([]byte, []int) {
+	return file_sih_v1_location_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *LocationStreamAccepted) GetDigitalId() string {
+	if x != nil {
+		return x.DigitalId
+	}
+	return ""
+}
+
+func (x *LocationStreamAccepted) GetHeartbeatSeconds() uint32 {
+	if x != nil {
+		return x.HeartbeatSeconds
+	}
+	return 0
+}
+
+func (x *LocationStreamAccepted) GetMaxInFlight() uint32 {
+	if x != nil {
+		return x.MaxInFlight
+	}
+	return 0
+}
+
+// LocationPingAck acknowledges a batch once it is stored.
+type LocationPingAck struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	Sequence uint64                 `protobuf:"varint,1,opt,name=sequence,proto3" json:"sequence,omitempty"`
+	Accepted uint32                 `protobuf:"varint,2,opt,name=accepted,proto3" json:"accepted,omitempty"`
+	// risk_level is the highest risk of the zones at the live position, or empty outside any zone.
+	RiskLevel string `protobuf:"bytes,3,opt,name=risk_level,json=riskLevel,proto3" json:"risk_level,omitempty"`
+	// safety_score is the tourist's updated safety score, when scoring is enabled.
+	SafetyScore   *int32 `protobuf:"varint,4,opt,name=safety_score,json=safetyScore,proto3,oneof" json:"safety_score,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LocationPingAck) Reset() {
+	*x = LocationPingAck{}
+	mi := &file_sih_v1_location_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LocationPingAck) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LocationPingAck) ProtoMessage() {}
+
+func (x *LocationPingAck) ProtoReflect() protoreflect.Message {
+	mi := &file_sih_v1_location_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LocationPingAck.ProtoReflect.Descriptor instead.
+func (*LocationPingAck) Descriptor() ([]byte, []int) {
+	return file_sih_v1_location_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *LocationPingAck) GetSequence() uint64 {
+	if x != nil {
+		return x.Sequence
+	}
+	return 0
+}
+
+func (x *LocationPingAck) GetAccepted() uint32 {
+	if x != nil {
+		return x.Accepted
+	}
+	return 0
+}
+
+func (x *LocationPingAck) GetRiskLevel() string {
+	if x != nil {
+		return x.RiskLevel
+	}
+	return ""
+}
+
+func (x *LocationPingAck) GetSafetyScore() int32 {
+	if x != nil && x.SafetyScore != nil {
+		return *x.SafetyScore
+	}
+	return 0
+}
+
+// ZoneAlert is a zone entry, exit or dwell breach caused by a batch, sent after its acknowledgement.
+type ZoneAlert struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Type          string                 `protobuf:"bytes,1,opt,name=type,proto3" json:"type,omitempty"`
+	ZoneId        string                 `protobuf:"bytes,2,opt,name=zone_id,json=zoneId,proto3" json:"zone_id,omitempty"`
+	Name          string                 `protobuf:"bytes,3,opt,name=name,proto3" json:"name,omitempty"`
+	RiskLevel     string                 `protobuf:"bytes,4,opt,name=risk_level,json=riskLevel,proto3" json:"risk_level,omitempty"`
+	Latitude      float64                `protobuf:"fixed64,5,opt,name=latitude,proto3" json:"latitude,omitempty"`
+	Longitude     float64                `protobuf:"fixed64,6,opt,name=longitude,proto3" json:"longitude,omitempty"`
+	At            string                 `protobuf:"bytes,7,opt,name=at,proto3" json:"at,omitempty"`
+	EnteredAt     string                 `protobuf:"bytes,8,opt,name=entered_at,json=enteredAt,proto3" json:"entered_at,omitempty"`
+	DwellSeconds  int64                  `protobuf:"varint,9,opt,name=dwell_seconds,json=dwellSeconds,proto3" json:"dwell_seconds,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ZoneAlert) Reset() {
+	*x = ZoneAlert{}
+	mi := &file_sih_v1_location_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ZoneAlert) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ZoneAlert) ProtoMessage() {}
+
+func (x *ZoneAlert) ProtoReflect() protoreflect.Message {
+	mi := &file_sih_v1_location_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ZoneAlert.ProtoReflect.Descriptor instead.
+func (*ZoneAlert) Descriptor() ([]byte, []int) {
+	return file_sih_v1_location_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *ZoneAlert) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *ZoneAlert) GetZoneId() string {
+	if x != nil {
+		return x.ZoneId
+	}
+	return ""
+}
+
+func (x *ZoneAlert) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *ZoneAlert) GetRiskLevel() string {
+	if x != nil {
+		return x.RiskLevel
+	}
+	return ""
+}
+
+func (x *ZoneAlert) GetLatitude() float64 {
+	if x != nil {
+		return x.Latitude
+	}
+	return 0
+}
+
+func (x *ZoneAlert) GetLongitude() float64 {
+	if x != nil {
+		return x.Longitude
+	}
+	return 0
+}
+
+func (x *ZoneAlert) GetAt() string {
+	if x != nil {
+		return x.At
+	}
+	return ""
+}
+
+func (x *ZoneAlert) GetEnteredAt() string {
+	if x != nil {
+		return x.EnteredAt
+	}
+	return ""
+}
+
+func (x *ZoneAlert) GetDwellSeconds() int64 {
+	if x != nil {
+		return x.DwellSeconds
+	}
+	return 0
+}
+
+var File_sih_v1_location_proto protoreflect.FileDescriptor
+
+const file_sih_v1_location_proto_rawDesc = "" +
+	"\n" +
+	"\x15sih/v1/location.proto\x12\x06sih.v1\"\xc5\x01\n" +
+	"\x15LocationStreamRequest\x123\n" +
+	"\x05start\x18\x01 \x01(\v2\x1b.sih.v1.StartLocationStreamH\x00R\x05start\x121\n" +
+	"\x05pings\x18\x02 \x01(\v2\x19.sih.v1.LocationPingBatchH\x00R\x05pings\x129\n" +
+	"\theartbeat\x18\x03 \x01(\v2\x19.sih.v1.LocationHeartbeatH\x00R\theartbeatB\t\n" +
+	"\amessage\"4\n" +
+	"\x13StartLocationStream\x12\x1d\n" +
+	"\n" +
+	"digital_id\x18\x01 \x01(\tR\tdigitalId\"[\n" +
+	"\x11LocationPingBatch\x12\x1a\n" +
+	"\bsequence\x18\x01 \x01(\x04R\bsequence\x12*\n" +
+	"\x05pings\x18\x02 \x03(\v2\x14.sih.v1.LocationPingR\x05pings\"\x80\x02\n" +
+	"\fLocationPing\x12\x1a\n" +
+	"\blatitude\x18\x01 \x01(\x01R\blatitude\x12\x1c\n" +
+	"\tlongitude\x18\x02 \x01(\x01R\tlongitude\x12\x1a\n" +
+	"\baccuracy\x18\x03 \x01(\x01R\baccuracy\x12\x19\n" +
+	"\x05speed\x18\x04 \x01(\x01H\x00R\x05speed\x88\x01\x01\x12\x1d\n" +
+	"\aheading\x18\x05 \x01(\x01H\x01R\aheading\x88\x01\x01\x12\x1d\n" +
+	"\abattery\x18\x06 \x01(\x05H\x02R\abattery\x88\x01\x01\x12\x1f\n" +
+	"\vrecorded_at\x18\a \x01(\tR\n" +
+	"recordedAtB\b\n" +
+	"\x06_speedB\n" +
+	"\n" +
+	"\b_headingB\n" +
+	"\n" +
+	"\b_battery\",\n" +
+	"\x11LocationHeartbeat\x12\x17\n" +
+	"\asent_at\x18\x01 \x01(\tR\x06sentAt\"\xf4\x01\n" +
+	"\x16LocationStreamResponse\x12<\n" +
+	"\baccepted\x18\x01 \x01(\v2\x1e.sih.v1.LocationStreamAcceptedH\x00R\baccepted\x12+\n" +
+	"\x03ack\x18\x02 \x01(\v2\x17.sih.v1.LocationPingAckH\x00R\x03ack\x12)\n" +
+	"\x05alert\x18\x03 \x01(\v2\x11.sih.v1.ZoneAlertH\x00R\x05alert\x129\n" +
+	"\theartbeat\x18\x04 \x01(\v2\x19.sih.v1.LocationHeartbeatH\x00R\theartbeatB\t\n" +
+	"\amessage\"\x88\x01\n" +
+	"\x16LocationStreamAccepted\x12\x1d\n" +
+	"\n" +
+	"digital_id\x18\x01 \x01(\tR\tdigitalId\x12+\n" +
+	"\x11heartbeat_seconds\x18\x02 \x01(\rR\x10heartbeatSeconds\x12\"\n" +
+	"\rmax_in_flight\x18\x03 \x01(\rR\vmaxInFlight\"\xa1\x01\n" +
+	"\x0fLocationPingAck\x12\x1a\n" +
+	"\bsequence\x18\x01 \x01(\x04R\bsequence\x12\x1a\n" +
+	"\baccepted\x18\x02 \x01(\rR\baccepted\x12\x1d\n" +
+	"\n" +
+	"risk_level\x18\x03 \x01(\tR\triskLevel\x12&\n" +
+	"\fsafety_score\x18\x04 \x01(\x05H\x00R\vsafetyScore\x88\x01\x01B\x0f\n" +
+	"\r_safety_score\"\xf9\x01\n" +
+	"\tZoneAlert\x12\x12\n" +
+	"\x04type\x18\x01 \x01(\tR\x04type\x12\x17\n" +
+	"\azone_id\x18\x02 \x01(\tR\x06zoneId\x12\x12\n" +
+	"\x04name\x18\x03 \x01(\tR\x04name\x12\x1d\n" +
+	"\n" +
+	"risk_level\x18\x04 \x01(\tR\triskLevel\x12\x1a\n" +
+	"\blatitude\x18\x05 \x01(\x01R\blatitude\x12\x1c\n" +
+	"\tlongitude\x18\x06 \x01(\x01R\tlongitude\x12\x0e\n" +
+	"\x02at\x18\a \x01(\tR\x02at\x12\x1d\n" +
+	"\n" +
+	"entered_at\x18\b \x01(\tR\tenteredAt\x12#\n" +
+	"\rdwell_seconds\x18\t \x01(\x03R\fdwellSeconds2f\n" +
+	"\x0fLocationService\x12S\n" +
+	"\x0eStreamLocation\x12\x1d.sih.v1.LocationStreamRequest\x1a\x1e.sih.v1.LocationStreamResponse(\x010\x01B\"Z assetTransfer/proto/sih/v1;sihv1b\x06proto3"
+
+var (
+	file_sih_v1_location_proto_rawDescOnce sync.Once
+	file_sih_v1_location_proto_rawDescData []byte
+)
+
+func file_sih_v1_location_proto_rawDescGZIP() []byte {
+	file_sih_v1_location_proto_rawDescOnce.Do(func() {
+		file_sih_v1_location_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_sih_v1_location_proto_rawDesc), len(file_sih_v1_location_proto_rawDesc)))
+	})
+	return file_sih_v1_location_proto_rawDescData
+}
+
+var file_sih_v1_location_proto_msgTypes = make([]protoimpl.MessageInfo, 9)
+var file_sih_v1_location_proto_goTypes = []any{
+	(*LocationStreamRequest)(nil),  // 0: sih.v1.LocationStreamRequest
+	(*StartLocationStream)(nil),    // 1: sih.v1.StartLocationStream
+	(*LocationPingBatch)(nil),      // 2: sih.v1.LocationPingBatch
+	(*LocationPing)(nil),           // 3: sih.v1.LocationPing
+	(*LocationHeartbeat)(nil),      // 4: sih.v1.LocationHeartbeat
+	(*LocationStreamResponse)(nil), // 5: sih.v1.LocationStreamResponse
+	(*LocationStreamAccepted)(nil), // 6: sih.v1.LocationStreamAccepted
+	(*LocationPingAck)(nil),        // 7: sih.v1.LocationPingAck
+	(*ZoneAlert)(nil),              // 8: sih.v1.ZoneAlert
+}
+var file_sih_v1_location_proto_depIdxs = []int32{
+	1, // 0: sih.v1.LocationStreamRequest.start:type_name -> sih.v1.StartLocationStream
+	2, // 1: sih.v1.LocationStreamRequest.pings:type_name -> sih.v1.LocationPingBatch
+	4, // 2: sih.v1.LocationStreamRequest.heartbeat:type_name -> sih.v1.LocationHeartbeat
+	3, // 3: sih.v1.LocationPingBatch.pings:type_name -> sih.v1.LocationPing
+	6, // 4: sih.v1.LocationStreamResponse.accepted:type_name -> sih.v1.LocationStreamAccepted
+	7, // 5: sih.v1.LocationStreamResponse.ack:type_name -> sih.v1.LocationPingAck
+	8, // 6: sih.v1.LocationStreamResponse.alert:type_name -> sih.v1.ZoneAlert
+	4, // 7: sih.v1.LocationStreamResponse.heartbeat:type_name -> sih.v1.LocationHeartbeat
+	0, // 8: sih.v1.LocationService.StreamLocation:input_type -> sih.v1.LocationStreamRequest
+	5, // 9: sih.v1.LocationService.StreamLocation:output_type -> sih.v1.LocationStreamResponse
+	9, // [9:10] is the sub-list for method output_type
+	8, // [8:9] is the sub-list for method input_type
+	8, // [8:8] is the sub-list for extension type_name
+	8, // [8:8] is the sub-list for extension extendee
+	0, // [0:8] is the sub-list for field type_name
+}
+
+func init() { file_sih_v1_location_proto_init() }
+func file_sih_v1_location_proto_init() {
+	if File_sih_v1_location_proto != nil {
+		return
+	}
+	file_sih_v1_location_proto_msgTypes[0].OneofWrappers = []any{
+		(*LocationStreamRequest_Start)(nil),
+		(*LocationStreamRequest_Pings)(nil),
+		(*LocationStreamRequest_Heartbeat)(nil),
+	}
+	file_sih_v1_location_proto_msgTypes[3].OneofWrappers = []any{}
+	file_sih_v1_location_proto_msgTypes[5].OneofWrappers = []any{
+		(*LocationStreamResponse_Accepted)(nil),
+		(*LocationStreamResponse_Ack)(nil),
+		(*LocationStreamResponse_Alert)(nil),
+		(*LocationStreamResponse_Heartbeat)(nil),
+	}
+	file_sih_v1_location_proto_msgTypes[7].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_sih_v1_location_proto_rawDesc), len(file_sih_v1_location_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   9,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_sih_v1_location_proto_goTypes,
+		DependencyIndexes: file_sih_v1_location_proto_depIdxs,
+		MessageInfos:      file_sih_v1_location_proto_msgTypes,
+	}.Build()
+	File_sih_v1_location_proto = out.File
+	file_sih_v1_location_proto_goTypes = nil
+	file_sih_v1_location_proto_depIdxs = nil
+}
@@ -0,0 +1,129 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: sih/v1/location.proto
+
+package sihv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	LocationService_StreamLocation_FullMethodName = "/sih.v1.LocationService/StreamLocation"
+)
+
+// LocationServiceClient is the client API for LocationService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// LocationService streams live locations from the tourist app and safety bands during a trip,
+// replacing repeated uploads to POST /api/v1/location/pings. It shares the REST implementation, so
+// pings are validated, stored and evaluated against geofences in the same way.
+type LocationServiceClient interface {
+	// StreamLocation carries one tourist's trip. The client opens it with a start message, then sends
+	// ping batches and heartbeats. The gateway acknowledges each batch and pushes the zone alerts it
+	// caused on the same stream.
+	StreamLocation(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[LocationStreamRequest, LocationStreamResponse], error)
+}
+
+type locationServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewLocationServiceClient(cc grpc.ClientConnInterface) LocationServiceClient {
+	return &locationServiceClient{cc}
+}
+
+func (c *locationServiceClient) StreamLocation(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[LocationStreamRequest, LocationStreamResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &LocationService_ServiceDesc.Streams[0], LocationService_StreamLocation_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[LocationStreamRequest, LocationStreamResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type LocationService_StreamLocationClient = grpc.BidiStreamingClient[LocationStreamRequest, LocationStreamResponse]
+
+// LocationServiceServer is the server API for LocationService service.
+// All implementations must embed UnimplementedLocationServiceServer
+// for forward compatibility.
+//
+// LocationService streams live locations from the tourist app and safety bands during a trip,
+// replacing repeated uploads to POST /api/v1/location/pings. It shares the REST implementation, so
+// pings are validated, stored and evaluated against geofences in the same way.
+type LocationServiceServer interface {
+	// StreamLocation carries one tourist's trip. The client opens it with a start message, then sends
+	// ping batches and heartbeats. The gateway acknowledges each batch and pushes the zone alerts it
+	// caused on the same stream.
+	StreamLocation(grpc.BidiStreamingServer[LocationStreamRequest, LocationStreamResponse]) error
+	mustEmbedUnimplementedLocationServiceServer()
+}
+
+// UnimplementedLocationServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedLocationServiceServer struct{}
+
+func (UnimplementedLocationServiceServer) StreamLocation(grpc.BidiStreamingServer[LocationStreamRequest, LocationStreamResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method StreamLocation not implemented")
+}
+func (UnimplementedLocationServiceServer) mustEmbedUnimplementedLocationServiceServer() {}
+func (UnimplementedLocationServiceServer) testEmbeddedByValue()                         {}
+
+// UnsafeLocationServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to LocationServiceServer will
+// result in compilation errors.
+type UnsafeLocationServiceServer interface {
+	mustEmbedUnimplementedLocationServiceServer()
+}
+
+func RegisterLocationServiceServer(s grpc.ServiceRegistrar, srv LocationServiceServer) {
+	// If the following call pancis, it indicates UnimplementedLocationServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&LocationService_ServiceDesc, srv)
+}
+
+func _LocationService_StreamLocation_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(LocationServiceServer).StreamLocation(&grpc.GenericServerStream[LocationStreamRequest, LocationStreamResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type LocationService_StreamLocationServer = grpc.BidiStreamingServer[LocationStreamRequest, LocationStreamResponse]
+
+// LocationService_ServiceDesc is the grpc.ServiceDesc for LocationService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var LocationService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "sih.v1.LocationService",
+	HandlerType: (*LocationServiceServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamLocation",
+			Handler:       _LocationService_StreamLocation_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "sih/v1/location.proto",
+}
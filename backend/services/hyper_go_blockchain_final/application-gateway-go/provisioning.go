@@ -0,0 +1,687 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	errCodeProvisioningDisabled = "PROVISIONING_DISABLED"
+	errCodeCARequestFailed      = "CA_REQUEST_FAILED"
+
+	// Attributes every provisioned officer's enrollment certificate carries, so chaincode can check
+	// the role with cid.GetAttributeValue as the gateway checks it with the RBAC policy
+	caRoleAttr   = "sih.role"
+	caOrgAttr    = "sih.org"
+	caAttrPrefix = "sih."
+
+	maxOfficerAttributes     = 10
+	maxOfficerAttributeValue = 128
+)
+
+var (
+	// fabricAttrsOID is the certificate extension Fabric CA writes enrolled attributes to
+	fabricAttrsOID = asn1.ObjectIdentifier{1, 2, 3, 4, 5, 6, 7, 8, 1}
+
+	// Usernames become the CA enrollment ID and the wallet file name, so they stay path-safe
+	officerUsernameRegex  = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+	officerAttributeRegex = regexp.MustCompile(`^[a-z][a-z0-9_]{0,31}$`)
+
+	// Revocation reasons Fabric CA accepts that fit an officer leaving
+	officerRevocationReasons = []string{"cessationofoperation", "affiliationchange", "privilegewithdrawn", "superseded", "keycompromise"}
+
+	errOfficerExists = errors.New("officer already has a wallet identity")
+	errOfficerAbsent = errors.New("officer has no wallet identity")
+)
+
+// ProvisionOfficerRequest registers a new officer with the Fabric CA, enrolls them and gives the
+// resulting identity an API key and a role, in one step. Attributes are added to the enrollment
+// certificate with the "sih." prefix.
+type ProvisionOfficerRequest struct {
+	Username    string            `json:"username" binding:"required"`
+	Role        string            `json:"role" binding:"required"`
+	Affiliation string            `json:"affiliation"`
+	Attributes  map[string]string `json:"attributes"`
+}
+
+func (r ProvisionOfficerRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	if !officerUsernameRegex.MatchString(r.Username) {
+		v.add("username", "must be 1-64 characters of letters, digits, '.', '_' or '-'")
+	}
+	v.identifier("role", r.Role)
+	if r.Affiliation != "" && !identifierRegex.MatchString(r.Affiliation) {
+		v.add("affiliation", "must be a dotted affiliation such as org1.department1")
+	}
+	if len(r.Attributes) > maxOfficerAttributes {
+		v.add("attributes", "must hold at most %d attributes", maxOfficerAttributes)
+	}
+	for name, value := range r.Attributes {
+		field := "attributes." + name
+		switch {
+		case !officerAttributeRegex.MatchString(name):
+			v.add(field, "name must be lowercase letters, digits and '_'")
+		case name == "role" || name == "org":
+			v.add(field, "is set by the gateway")
+		case value == "" || len(value) > maxOfficerAttributeValue:
+			v.add(field, "must be 1-%d characters", maxOfficerAttributeValue)
+		}
+	}
+	return v.errors
+}
+
+// DeprovisionOfficerRequest gives the reason recorded when the officer's certificates are revoked
+type DeprovisionOfficerRequest struct {
+	Reason string `json:"reason"`
+}
+
+func (r DeprovisionOfficerRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	if r.Reason != "" {
+		v.oneOf("reason", r.Reason, officerRevocationReasons)
+	}
+	return v.errors
+}
+
+// ProvisionedOfficer is a newly enrolled officer. The API key is only ever returned here; the
+// gateway keeps its hash.
+type ProvisionedOfficer struct {
+	Label         string            `json:"label"`
+	MSPID         string            `json:"msp_id"`
+	Role          string            `json:"role"`
+	Affiliation   string            `json:"affiliation,omitempty"`
+	Attributes    map[string]string `json:"attributes"`
+	Serial        string            `json:"certificate_serial"`
+	NotAfter      string            `json:"certificate_expires_at"`
+	APIKey        string            `json:"api_key"`
+	ProvisionedAt string            `json:"provisioned_at"`
+}
+
+// Officer is a wallet identity with the roles the policy binds to it
+type Officer struct {
+	Label string   `json:"label"`
+	MSPID string   `json:"msp_id"`
+	Roles []string `json:"roles"`
+}
+
+// DeprovisionedOfficer reports what was removed when an officer left
+type DeprovisionedOfficer struct {
+	Label               string `json:"label"`
+	RevokedCertificates int    `json:"revoked_certificates"`
+	RevokedSessions     int    `json:"revoked_sessions"`
+	DeprovisionedAt     string `json:"deprovisioned_at"`
+}
+
+// caError is a request the Fabric CA refused or failed
+type caError struct {
+	status  int
+	message string
+}
+
+func (e *caError) Error() string {
+	return fmt.Sprintf("Fabric CA responded with %d: %s", e.status, e.message)
+}
+
+// caClient calls a Fabric CA server's REST API, authenticating registrar calls with the token
+// Fabric CA clients send: the registrar's certificate and its signature over the request
+type caClient struct {
+	url        string
+	caName     string
+	cert       []byte
+	key        *ecdsa.PrivateKey
+	httpClient *http.Client
+}
+
+// caAttribute is an attribute registered for an identity; ECert ones are added to enrollments
+type caAttribute struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+	ECert bool   `json:"ecert"`
+}
+
+type caRegistration struct {
+	ID             string        `json:"id"`
+	Type           string        `json:"type"`
+	Secret         string        `json:"secret"`
+	MaxEnrollments int           `json:"max_enrollments"`
+	Affiliation    string        `json:"affiliation"`
+	Attributes     []caAttribute `json:"attrs"`
+	CAName         string        `json:"caname,omitempty"`
+}
+
+type caAttributeRequest struct {
+	Name     string `json:"name"`
+	Optional bool   `json:"optional"`
+}
+
+type caEnrollment struct {
+	CSR    string `json:"certificate_request"`
+	CAName string `json:"caname,omitempty"`
+	// Attributes left out ask the CA for the identity's ECert attributes
+	Attributes []caAttributeRequest `json:"attr_reqs,omitempty"`
+}
+
+type caModification struct {
+	Secret string `json:"secret"`
+	CAName string `json:"caname,omitempty"`
+}
+
+type caRevocation struct {
+	ID     string `json:"id"`
+	Reason string `json:"reason"`
+	CAName string `json:"caname,omitempty"`
+}
+
+type caResponse struct {
+	Success bool            `json:"success"`
+	Result  json.RawMessage `json:"result"`
+	Errors  []struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// register creates an identity the officer can enroll once with secret
+func (c *caClient) register(ctx context.Context, reg caRegistration) error {
+	reg.CAName = c.caName
+	return c.call(ctx, http.MethodPost, "/api/v1/register", reg, c.authorizeToken, nil)
+}
+
+// enroll exchanges the enrollment secret and a CSR for a certificate carrying the requested
+// attributes, returned as PEM
+func (c *caClient) enroll(ctx context.Context, id, secret string, csr []byte, attrs []string) ([]byte, error) {
+	req := caEnrollment{CSR: string(csr), CAName: c.caName}
+	for _, name := range attrs {
+		req.Attributes = append(req.Attributes, caAttributeRequest{Name: name})
+	}
+	var result struct {
+		Cert string `json:"Cert"`
+	}
+	basic := func(r *http.Request, _ []byte) error {
+		r.SetBasicAuth(id, secret)
+		return nil
+	}
+	if err := c.call(ctx, http.MethodPost, "/api/v1/enroll", req, basic, &result); err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(result.Cert)
+}
+
+// revoke revokes every certificate of an identity and disables further enrollment, returning how
+// many certificates were revoked
+func (c *caClient) revoke(ctx context.Context, id, reason string) (int, error) {
+	var result struct {
+		RevokedCerts []json.RawMessage `json:"RevokedCerts"`
+	}
+	if err := c.call(ctx, http.MethodPost, "/api/v1/revoke", caRevocation{ID: id, Reason: reason, CAName: c.caName}, c.authorizeToken, &result); err != nil {
+		return 0, err
+	}
+	return len(result.RevokedCerts), nil
+}
+
+// modify gives an existing identity a new enrollment secret, so the gateway can enroll it again
+func (c *caClient) modify(ctx context.Context, id, secret string) error {
+	return c.call(ctx, http.MethodPut, "/api/v1/identities/"+url.PathEscape(id), caModification{Secret: secret, CAName: c.caName}, c.authorizeToken, nil)
+}
+
+// chain returns the CA's certificate chain, to check certificates it issued
+func (c *caClient) chain(ctx context.Context) (*x509.CertPool, error) {
+	var result struct {
+		CAChain string `json:"CAChain"`
+	}
+	path := "/api/v1/cainfo"
+	if c.caName != "" {
+		path += "?ca=" + url.QueryEscape(c.caName)
+	}
+	if err := c.call(ctx, http.MethodGet, path, nil, noAuthorization, &result); err != nil {
+		return nil, err
+	}
+	chain, err := base64.StdEncoding.DecodeString(result.CAChain)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(chain) {
+		return nil, errors.New("the CA returned no chain")
+	}
+	return pool, nil
+}
+
+// issue enrolls a CSR and checks the certificate carries every ECert attribute in attrs. Without
+// attrs the CA adds the identity's default attributes and nothing is checked.
+func (c *caClient) issue(ctx context.Context, id, secret string, csr []byte, attrs []caAttribute) (*x509.Certificate, []byte, error) {
+	var names []string
+	for _, attr := range attrs {
+		names = append(names, attr.Name)
+	}
+	certPEM, err := c.enroll(ctx, id, secret, csr, names)
+	if err != nil {
+		return nil, nil, err
+	}
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return nil, nil, errors.New("the CA returned no certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	embedded, err := certificateAttributes(cert)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, attr := range attrs {
+		if embedded[attr.Name] != attr.Value {
+			return nil, nil, fmt.Errorf("the enrollment certificate does not carry %s=%s; check the registrar may assign it", attr.Name, attr.Value)
+		}
+	}
+	return cert, certPEM, nil
+}
+
+func noAuthorization(*http.Request, []byte) error { return nil }
+
+func (c *caClient) call(ctx context.Context, method, path string, body interface{}, authorize func(*http.Request, []byte) error, result interface{}) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.url+path, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if err := authorize(req, payload); err != nil {
+		return err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var envelope caResponse
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return &caError{status: resp.StatusCode, message: string(bytes.TrimSpace(data))}
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated || !envelope.Success {
+		messages := make([]string, 0, len(envelope.Errors))
+		for _, e := range envelope.Errors {
+			messages = append(messages, fmt.Sprintf("%s (code %d)", e.Message, e.Code))
+		}
+		return &caError{status: resp.StatusCode, message: strings.Join(messages, "; ")}
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(envelope.Result, result)
+}
+
+// authorizeToken sets the registrar token: base64(cert) "." base64(signature), the signature
+// covering method, URI, body and certificate as Fabric CA verifies it
+func (c *caClient) authorizeToken(req *http.Request, body []byte) error {
+	b64cert := base64.StdEncoding.EncodeToString(c.cert)
+	payload := req.Method + "." + base64.StdEncoding.EncodeToString([]byte(req.URL.RequestURI())) + "." +
+		base64.StdEncoding.EncodeToString(body) + "." + b64cert
+	digest := sha256.Sum256([]byte(payload))
+	signature, err := signLowS(c.key, digest[:])
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", b64cert+"."+base64.StdEncoding.EncodeToString(signature))
+	return nil
+}
+
+// signLowS signs digest with the S value in the lower half of the curve order, which Fabric
+// requires to rule out malleable signatures
+func signLowS(key *ecdsa.PrivateKey, digest []byte) ([]byte, error) {
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest)
+	if err != nil {
+		return nil, err
+	}
+	n := key.Curve.Params().N
+	if s.Cmp(new(big.Int).Rsh(n, 1)) > 0 {
+		s.Sub(n, s)
+	}
+	return asn1.Marshal(struct{ R, S *big.Int }{r, s})
+}
+
+// certificateAttributes reads the attributes Fabric CA embedded in an enrollment certificate
+func certificateAttributes(cert *x509.Certificate) (map[string]string, error) {
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(fabricAttrsOID) {
+			continue
+		}
+		var embedded struct {
+			Attrs map[string]string `json:"attrs"`
+		}
+		if err := json.Unmarshal(ext.Value, &embedded); err != nil {
+			return nil, fmt.Errorf("invalid attribute extension: %w", err)
+		}
+		return embedded.Attrs, nil
+	}
+	return map[string]string{}, nil
+}
+
+// provisioner keeps the Fabric CA, the gateway wallet and the RBAC policy in step as officers join
+// and leave
+type provisioner struct {
+	ca          *caClient
+	mspID       string
+	affiliation string
+
+	// mu serializes provisioning so one username is never registered twice at once
+	mu sync.Mutex
+}
+
+var provisioning *provisioner
+
+// initProvisioning enables the officer provisioning API when FABRIC_CA_URL is set. Calls to the CA
+// are made as the registrar in FABRIC_CA_REGISTRAR_CERT and FABRIC_CA_REGISTRAR_KEY.
+func initProvisioning() {
+	endpoint := getEnv("FABRIC_CA_URL", "")
+	if endpoint == "" {
+		log.Println("🪪 FABRIC_CA_URL not set, officers are enrolled and added to the wallet by hand")
+		return
+	}
+	if callerWallet == nil || access == nil {
+		panic(fmt.Errorf("officer provisioning requires WALLET_PATH and RBAC_POLICY_FILE, which it keeps in step with the CA"))
+	}
+
+	certPEM, err := os.ReadFile(getEnv("FABRIC_CA_REGISTRAR_CERT", ""))
+	if err != nil {
+		panic(fmt.Errorf("failed to read FABRIC_CA_REGISTRAR_CERT: %w", err))
+	}
+	keyPEM, err := os.ReadFile(getEnv("FABRIC_CA_REGISTRAR_KEY", ""))
+	if err != nil {
+		panic(fmt.Errorf("failed to read FABRIC_CA_REGISTRAR_KEY: %w", err))
+	}
+	key, err := parseECPrivateKey(keyPEM)
+	if err != nil {
+		panic(fmt.Errorf("invalid FABRIC_CA_REGISTRAR_KEY: %w", err))
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if rootFile := getEnv("FABRIC_CA_TLS_CERT", ""); rootFile != "" {
+		root, err := os.ReadFile(rootFile)
+		if err != nil {
+			panic(fmt.Errorf("failed to read FABRIC_CA_TLS_CERT: %w", err))
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(root) {
+			panic(fmt.Errorf("FABRIC_CA_TLS_CERT holds no PEM certificates"))
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+
+	provisioning = &provisioner{
+		ca: &caClient{
+			url:        strings.TrimRight(endpoint, "/"),
+			caName:     getEnv("FABRIC_CA_NAME", ""),
+			cert:       certPEM,
+			key:        key,
+			httpClient: &http.Client{Transport: transport, Timeout: getEnvDuration("FABRIC_CA_TIMEOUT", 15*time.Second)},
+		},
+		mspID:       mspID,
+		affiliation: getEnv("FABRIC_CA_AFFILIATION", ""),
+	}
+	log.Printf("🪪 Provisioning %s officers through the Fabric CA at %s", mspID, endpoint)
+}
+
+// parseECPrivateKey reads a PKCS#8 or SEC 1 ECDSA key, as Fabric CA and cryptogen write them
+func parseECPrivateKey(data []byte) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block")
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("not an ECDSA key")
+	}
+	return key, nil
+}
+
+// provision registers and enrolls an officer, then adds the identity to the wallet and binds its
+// role. A failure after registration revokes the CA identity so it can never be enrolled, and
+// leaves nothing in the wallet or policy.
+func (p *provisioner) provision(ctx context.Context, req ProvisionOfficerRequest) (*ProvisionedOfficer, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if callerWallet.identity(req.Username) != nil {
+		return nil, errOfficerExists
+	}
+	if !access.current().defines(req.Role) {
+		return nil, ValidationErrors{{Field: "role", Message: "is not a role in the access policy"}}
+	}
+
+	affiliation := req.Affiliation
+	if affiliation == "" {
+		affiliation = p.affiliation
+	}
+	attrs := []caAttribute{{Name: caRoleAttr, Value: req.Role, ECert: true}, {Name: caOrgAttr, Value: p.mspID, ECert: true}}
+	for name, value := range req.Attributes {
+		attrs = append(attrs, caAttribute{Name: caAttrPrefix + name, Value: value, ECert: true})
+	}
+	secret := randomToken(24)
+	if err := p.ca.register(ctx, caRegistration{ID: req.Username, Type: "client", Secret: secret, MaxEnrollments: 1, Affiliation: affiliation, Attributes: attrs}); err != nil {
+		return nil, err
+	}
+
+	officer, err := p.enroll(ctx, req, secret, attrs)
+	if err != nil {
+		if _, revokeErr := p.ca.revoke(context.WithoutCancel(ctx), req.Username, "cessationofoperation"); revokeErr != nil {
+			log.Printf("⚠️  Provisioning %s failed and its CA registration could not be revoked: %v", req.Username, revokeErr)
+		}
+		return nil, err
+	}
+	officer.Affiliation = affiliation
+	log.Printf("🪪 Provisioned officer %s as %s", officer.Label, officer.Role)
+	return officer, nil
+}
+
+// enroll requests the officer's certificate, checks the CA embedded the role, and adds the identity
+// to the wallet and policy
+func (p *provisioner) enroll(ctx context.Context, req ProvisionOfficerRequest, secret string, attrs []caAttribute) (*ProvisionedOfficer, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: req.Username}}, key)
+	if err != nil {
+		return nil, err
+	}
+	cert, certPEM, err := p.ca.issue(ctx, req.Username, secret, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr}), attrs)
+	if err != nil {
+		return nil, err
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	var file walletFile
+	file.Version, file.MSPID, file.Type = 1, p.mspID, "X.509"
+	file.Credentials.Certificate = string(certPEM)
+	file.Credentials.PrivateKey = string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}))
+	apiKey := randomToken(32)
+	if _, err := callerWallet.add(req.Username, file, hashToken(apiKey)); err != nil {
+		return nil, err
+	}
+	if _, err := access.bindRole("wallet:"+req.Username, req.Role); err != nil {
+		if removeErr := callerWallet.remove(req.Username); removeErr != nil {
+			log.Printf("⚠️  Wallet identity %s could not be removed after its role binding failed: %v", req.Username, removeErr)
+		}
+		return nil, err
+	}
+
+	officer := &ProvisionedOfficer{
+		Label: req.Username, MSPID: p.mspID, Role: req.Role, Attributes: map[string]string{},
+		Serial: cert.SerialNumber.Text(16), NotAfter: cert.NotAfter.UTC().Format(time.RFC3339),
+		APIKey: apiKey, ProvisionedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+	for name, value := range req.Attributes {
+		officer.Attributes[name] = value
+	}
+	return officer, nil
+}
+
+// deprovision revokes the officer's certificates at the CA first, so an officer who has left can no
+// longer sign even if removing the local state fails, then drops their role bindings, wallet
+// identity, API keys and sessions
+func (p *provisioner) deprovision(ctx context.Context, label, reason string) (*DeprovisionedOfficer, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if callerWallet.identity(label) == nil {
+		return nil, errOfficerAbsent
+	}
+	if reason == "" {
+		reason = officerRevocationReasons[0]
+	}
+	revoked, err := p.ca.revoke(ctx, label, reason)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := access.bindRole("wallet:"+label, ""); err != nil {
+		return nil, err
+	}
+	if err := callerWallet.remove(label); err != nil {
+		return nil, err
+	}
+	result := &DeprovisionedOfficer{Label: label, RevokedCertificates: revoked, DeprovisionedAt: time.Now().UTC().Format(time.RFC3339)}
+	if sessions != nil {
+		if result.RevokedSessions, err = sessions.revokeSubject(ctx, label); err != nil {
+			log.Printf("⚠️  Sessions of deprovisioned officer %s could not be revoked: %v", label, err)
+		}
+	}
+	log.Printf("🪪 Deprovisioned officer %s: %d certificates revoked (%s)", label, revoked, reason)
+	return result, nil
+}
+
+// officers lists the wallet identities with their role bindings
+func (p *provisioner) officers() []Officer {
+	policy := access.current()
+	officers := []Officer{}
+	for _, label := range callerWallet.labels() {
+		id := callerWallet.identity(label)
+		if id == nil {
+			continue
+		}
+		officers = append(officers, Officer{Label: label, MSPID: id.MSPID, Roles: policy.roles([]string{"wallet:" + label})})
+	}
+	return officers
+}
+
+// respondProvisioningDisabled answers provisioning calls when no CA is configured
+func respondProvisioningDisabled(c *gin.Context) bool {
+	if provisioning != nil {
+		return false
+	}
+	respondError(c, http.StatusServiceUnavailable, errCodeProvisioningDisabled, "Officer provisioning is not enabled; set FABRIC_CA_URL")
+	return true
+}
+
+func respondProvisioningError(c *gin.Context, action string, err error) {
+	var caErr *caError
+	switch {
+	case errors.Is(err, errOfficerExists):
+		respondError(c, http.StatusConflict, errCodeAlreadyExists, "The officer already has a wallet identity")
+	case errors.Is(err, errOfficerAbsent):
+		respondError(c, http.StatusNotFound, errCodeNotFound, "The officer has no wallet identity")
+	case errors.As(err, &caErr):
+		logWithContext(c.Request.Context(), "%s: %v", action, err)
+		respondError(c, http.StatusBadGateway, errCodeCARequestFailed, caErr.Error())
+	default:
+		respondServiceError(c, action, err)
+	}
+}
+
+func listOfficers(c *gin.Context) {
+	if respondProvisioningDisabled(c) {
+		return
+	}
+	respondData(c, http.StatusOK, provisioning.officers())
+}
+
+func provisionOfficer(c *gin.Context) {
+	if respondProvisioningDisabled(c) {
+		return
+	}
+	var req ProvisionOfficerRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+	setAuditTarget(c, req.Username)
+	officer, err := provisioning.provision(c.Request.Context(), req)
+	if err != nil {
+		respondProvisioningError(c, "Failed to provision officer", err)
+		return
+	}
+	respondData(c, http.StatusCreated, officer)
+}
+
+func deprovisionOfficer(c *gin.Context) {
+	if respondProvisioningDisabled(c) {
+		return
+	}
+	label := c.Param("label")
+	if !officerUsernameRegex.MatchString(label) {
+		respondValidationErrors(c, ValidationErrors{{Field: "label", Message: "must be a wallet identity label"}})
+		return
+	}
+	var req DeprovisionOfficerRequest
+	if c.Request.ContentLength != 0 && !bindRequest(c, &req) {
+		return
+	}
+	// An administrator removing their own identity would lock themselves out mid-request
+	if id, _ := c.Request.Context().Value(signerContextKey{}).(*walletIdentity); id != nil && id.Label == label {
+		respondValidationErrors(c, ValidationErrors{{Field: "label", Message: "must not be the caller's own identity"}})
+		return
+	}
+	result, err := provisioning.deprovision(c.Request.Context(), label, req.Reason)
+	if err != nil {
+		respondProvisioningError(c, "Failed to deprovision officer", err)
+		return
+	}
+	respondData(c, http.StatusOK, result)
+}
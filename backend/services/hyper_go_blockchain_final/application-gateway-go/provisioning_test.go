@@ -0,0 +1,270 @@
+package gateway
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeCA answers the register, enroll and revoke calls the way a Fabric CA server does, verifying
+// the registrar token
+type fakeCA struct {
+	t         *testing.T
+	key       *ecdsa.PrivateKey
+	root      *x509.Certificate
+	registrar *ecdsa.PublicKey
+	// dropAttr is left out of issued certificates, as when the registrar may not assign it
+	dropAttr string
+
+	mu         sync.Mutex
+	registered map[string]caRegistration
+	revoked    map[string]bool
+}
+
+func (ca *fakeCA) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	fail := func(message string) {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "errors": []map[string]interface{}{{"code": 20, "message": message}}})
+	}
+	ok := func(result interface{}) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "result": result})
+	}
+	if r.URL.Path == "/api/v1/cainfo" {
+		ok(map[string]string{"CAChain": base64.StdEncoding.EncodeToString(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.root.Raw}))})
+		return
+	}
+	if r.URL.Path != "/api/v1/enroll" && !ca.verifyToken(r, body) {
+		fail("Authorization failure")
+		return
+	}
+
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	if id, found := strings.CutPrefix(r.URL.Path, "/api/v1/identities/"); found && r.Method == http.MethodPut {
+		var req caModification
+		json.Unmarshal(body, &req)
+		reg := ca.registered[id]
+		reg.Secret = req.Secret
+		ca.registered[id] = reg
+		ok(map[string]string{"id": id})
+		return
+	}
+	switch r.URL.Path {
+	case "/api/v1/register":
+		var reg caRegistration
+		json.Unmarshal(body, &reg)
+		ca.registered[reg.ID] = reg
+		ok(map[string]string{"secret": reg.Secret})
+	case "/api/v1/enroll":
+		id, secret, _ := r.BasicAuth()
+		reg, found := ca.registered[id]
+		if !found || reg.Secret != secret || ca.revoked[id] {
+			fail("Failed to get user")
+			return
+		}
+		var req caEnrollment
+		json.Unmarshal(body, &req)
+		block, _ := pem.Decode([]byte(req.CSR))
+		csr, err := x509.ParseCertificateRequest(block.Bytes)
+		if err != nil {
+			ca.t.Fatal(err)
+		}
+		attrs := map[string]string{}
+		for _, attr := range reg.Attributes {
+			if attr.ECert && attr.Name != ca.dropAttr {
+				attrs[attr.Name] = attr.Value
+			}
+		}
+		ext, _ := json.Marshal(map[string]interface{}{"attrs": attrs})
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(int64(len(ca.registered) + 1)), Subject: csr.Subject,
+			NotBefore: time.Now(), NotAfter: time.Now().Add(365 * 24 * time.Hour),
+			ExtraExtensions: []pkix.Extension{{Id: fabricAttrsOID, Value: ext}},
+		}
+		der, _ := x509.CreateCertificate(rand.Reader, template, ca.root, csr.PublicKey, ca.key)
+		ok(map[string]string{"Cert": base64.StdEncoding.EncodeToString(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))})
+	case "/api/v1/revoke":
+		var req caRevocation
+		json.Unmarshal(body, &req)
+		if _, found := ca.registered[req.ID]; !found {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "errors": []map[string]interface{}{{"code": 63, "message": "Identity not found"}}})
+			return
+		}
+		ca.revoked[req.ID] = true
+		ok(map[string]interface{}{"RevokedCerts": []map[string]string{{"Serial": "1"}}})
+	}
+}
+
+func (ca *fakeCA) verifyToken(r *http.Request, body []byte) bool {
+	b64cert, b64sig, found := strings.Cut(r.Header.Get("Authorization"), ".")
+	signature, err := base64.StdEncoding.DecodeString(b64sig)
+	if !found || err != nil {
+		return false
+	}
+	payload := r.Method + "." + base64.StdEncoding.EncodeToString([]byte(r.URL.RequestURI())) + "." +
+		base64.StdEncoding.EncodeToString(body) + "." + b64cert
+	digest := sha256.Sum256([]byte(payload))
+	var sig struct{ R, S *big.Int }
+	if _, err := asn1.Unmarshal(signature, &sig); err != nil {
+		return false
+	}
+	halfOrder := new(big.Int).Rsh(elliptic.P256().Params().N, 1)
+	return sig.S.Cmp(halfOrder) <= 0 && ecdsa.Verify(ca.registrar, digest[:], sig.R, sig.S)
+}
+
+// newFakeCA starts a CA that accepts the returned client's registrar token
+func newFakeCA(t *testing.T) (*fakeCA, *caClient) {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "ca.org1"}, IsCA: true, BasicConstraintsValid: true,
+		KeyUsage: x509.KeyUsageCertSign, NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(10 * 365 * 24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, _ := x509.ParseCertificate(der)
+	registrarKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ca := &fakeCA{t: t, key: key, root: root, registrar: &registrarKey.PublicKey, registered: map[string]caRegistration{}, revoked: map[string]bool{}}
+	server := httptest.NewServer(ca)
+	t.Cleanup(server.Close)
+	return ca, &caClient{url: server.URL, cert: []byte("registrar certificate"), key: registrarKey, httpClient: server.Client()}
+}
+
+func TestProvisionOfficer(t *testing.T) {
+	ca, client := newFakeCA(t)
+
+	dir := t.TempDir()
+	policyFile := filepath.Join(dir, "policy.csv")
+	os.WriteFile(policyFile, []byte(testPolicy), 0o600)
+	previousWallet, previousAccess := callerWallet, access
+	defer func() { callerWallet, access = previousWallet, previousAccess }()
+	callerWallet = &wallet{dir: dir, callersFile: filepath.Join(dir, "callers.json"), identities: map[string]*walletIdentity{}, callers: map[string]string{}}
+	access = &accessControl{file: policyFile}
+	if err := access.reload(); err != nil {
+		t.Fatal(err)
+	}
+	p := &provisioner{
+		ca:    client,
+		mspID: "Org1MSP",
+	}
+	ctx := context.Background()
+
+	officer, err := p.provision(ctx, ProvisionOfficerRequest{Username: "officer-ravi", Role: "officer", Attributes: map[string]string{"district": "kamrup"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reg := ca.registered["officer-ravi"]; reg.MaxEnrollments != 1 || len(reg.Attributes) != 3 {
+		t.Errorf("unexpected registration %+v", reg)
+	}
+	if id, err := callerWallet.resolve(officer.APIKey); err != nil || id == nil || id.Label != "officer-ravi" || id.MSPID != "Org1MSP" {
+		t.Errorf("expected the API key to sign as the officer, got %v %v", id, err)
+	}
+	// The wallet and callers file survive a restart
+	files, err := readWalletDir(dir)
+	if err != nil || files["officer-ravi"].Credentials.PrivateKey == "" {
+		t.Errorf("expected the identity file written, got %v %v", files, err)
+	}
+	if callers, err := readWalletCallers(callerWallet.callersFile); err != nil || callers[hashToken(officer.APIKey)] != "officer-ravi" {
+		t.Errorf("expected the API key hash saved, got %v %v", callers, err)
+	}
+	saved, _ := os.ReadFile(policyFile)
+	if !strings.Contains(string(saved), "g, wallet:officer-ravi, officer") || !strings.Contains(string(saved), "# district officers") {
+		t.Errorf("expected the binding appended to the policy file, got %s", saved)
+	}
+	if officers := p.officers(); len(officers) != 1 || strings.Join(officers[0].Roles, ",") != "officer" {
+		t.Errorf("unexpected officers %+v", officers)
+	}
+
+	if _, err := p.provision(ctx, ProvisionOfficerRequest{Username: "officer-ravi", Role: "officer"}); !errors.Is(err, errOfficerExists) {
+		t.Errorf("expected a second provisioning refused, got %v", err)
+	}
+	if _, err := p.provision(ctx, ProvisionOfficerRequest{Username: "officer-anu", Role: "ranger"}); err == nil || !strings.Contains(err.Error(), "role") {
+		t.Errorf("expected a role missing from the policy refused, got %v", err)
+	}
+
+	// A certificate without the role is not trusted: the registration is revoked and nothing is kept
+	ca.dropAttr = caRoleAttr
+	if _, err := p.provision(ctx, ProvisionOfficerRequest{Username: "officer-anu", Role: "officer"}); err == nil {
+		t.Fatal("expected an enrollment without the role attribute to fail")
+	}
+	if !ca.revoked["officer-anu"] || callerWallet.identity("officer-anu") != nil || strings.Contains(access.current().text, "officer-anu") {
+		t.Error("expected the failed provisioning rolled back")
+	}
+
+	result, err := p.deprovision(ctx, "officer-ravi", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.RevokedCertificates != 1 || !ca.revoked["officer-ravi"] {
+		t.Errorf("expected the CA identity revoked, got %+v", result)
+	}
+	if _, err := callerWallet.resolve(officer.APIKey); err == nil {
+		t.Error("expected the officer's API key rejected")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "officer-ravi"+walletFileSuffix)); !os.IsNotExist(err) {
+		t.Errorf("expected the identity file removed, got %v", err)
+	}
+	if roles := access.current().roles([]string{"wallet:officer-ravi"}); len(roles) != 0 {
+		t.Errorf("expected the role binding removed, got %v", roles)
+	}
+
+	// When the CA cannot revoke, the officer keeps their local state so the call can be retried
+	callerWallet.identities["ops"] = &walletIdentity{Label: "ops"}
+	var caErr *caError
+	if _, err := p.deprovision(ctx, "ops", ""); !errors.As(err, &caErr) || callerWallet.identity("ops") == nil {
+		t.Errorf("expected the CA's refusal to leave the wallet untouched, got %v", err)
+	}
+}
+
+func TestRebindSubject(t *testing.T) {
+	text := "# staff\np, officer, incident, read\ng, wallet:ravi, officer\ng, wallet:ravi-2, officer\n"
+	if got := rebindSubject(text, "wallet:ravi", "supervisor"); got != "# staff\np, officer, incident, read\ng, wallet:ravi-2, officer\ng, wallet:ravi, supervisor\n" {
+		t.Errorf("unexpected rebinding %q", got)
+	}
+	if got := rebindSubject(text, "wallet:ravi", ""); strings.Contains(got, "wallet:ravi,") {
+		t.Errorf("expected the binding removed, got %q", got)
+	}
+}
+
+func TestProvisionOfficerValidation(t *testing.T) {
+	cases := []struct {
+		req   ProvisionOfficerRequest
+		field string
+	}{
+		{ProvisionOfficerRequest{Username: "../ops", Role: "officer"}, "username"},
+		{ProvisionOfficerRequest{Username: "ravi", Role: "officer", Attributes: map[string]string{"Role": "x"}}, "attributes.Role"},
+		{ProvisionOfficerRequest{Username: "ravi", Role: "officer", Attributes: map[string]string{"role": "admin"}}, "attributes.role"},
+		{ProvisionOfficerRequest{Username: "ravi", Role: "officer", Attributes: map[string]string{"district": ""}}, "attributes.district"},
+	}
+	for _, tc := range cases {
+		if errs := tc.req.Validate(); len(errs) != 1 || errs[0].Field != tc.field {
+			t.Errorf("expected %s rejected, got %v", tc.field, errs)
+		}
+	}
+	if errs := (DeprovisionOfficerRequest{Reason: "retired"}).Validate(); len(errs) != 1 {
+		t.Errorf("expected an unknown revocation reason rejected, got %v", errs)
+	}
+}
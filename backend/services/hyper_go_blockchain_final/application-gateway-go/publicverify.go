@@ -0,0 +1,165 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// firNumberPattern matches the register numbers AllocateFIRNumber hands out, such as 0042/2026
+var firNumberPattern = regexp.MustCompile(`^[0-9]{4,}/[0-9]{4}$`)
+
+// PublicFIRVerifyRequest identifies a FIR by its ID, or by its number in a police station's
+// register. With DocumentHash, the FIR is only valid when the SHA-256 of the copy in hand matches
+// the anchored one, which catches a forged copy that reuses a real number.
+type PublicFIRVerifyRequest struct {
+	FIRID         string `form:"firId"`
+	FIRNumber     string `form:"firNumber"`
+	District      string `form:"district"`
+	PoliceStation string `form:"policeStation"`
+	DocumentHash  string `form:"documentHash"`
+}
+
+func (r PublicFIRVerifyRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	switch {
+	case r.FIRID != "" && r.FIRNumber != "":
+		v.add("firNumber", "must not be given with firId")
+	case r.FIRID != "":
+		v.identifier("firId", r.FIRID)
+	case r.FIRNumber != "":
+		if !firNumberPattern.MatchString(r.FIRNumber) {
+			v.add("firNumber", "must be a register number such as 0042/2026")
+		}
+		if r.District == "" || r.PoliceStation == "" || len(r.District) > 256 || len(r.PoliceStation) > 256 {
+			v.add("policeStation", "district and police station are required with firNumber, at most 256 characters each")
+		}
+	default:
+		v.add("firId", "is required unless firNumber is given")
+	}
+	if r.DocumentHash != "" {
+		v.sha256("documentHash", r.DocumentHash)
+	}
+	return v.errors
+}
+
+// PublicVerification is all the public portal reveals: whether the document is anchored on the
+// ledger and when. Everything else about it stays behind the API key.
+type PublicVerification struct {
+	Valid      bool   `json:"valid"`
+	AnchoredAt string `json:"anchored_at,omitempty"`
+}
+
+// HashAnchor is the chaincode's answer to whether a hash is anchored as evidence or an e-FIR
+type HashAnchor struct {
+	Anchored   bool   `json:"anchored"`
+	AnchoredAt string `json:"anchored_at,omitempty"`
+}
+
+// PublicVerifyFIR checks that a FIR was filed on the ledger, and optionally that a copy of it is
+// the document anchored. A FIR that does not exist, or whose number was allocated but never filed,
+// is reported invalid rather than as an error.
+func (s ledgerService) PublicVerifyFIR(ctx context.Context, req PublicFIRVerifyRequest) (PublicVerification, error) {
+	if errs := req.Validate(); len(errs) > 0 {
+		return PublicVerification{}, errs
+	}
+	firID := req.FIRID
+	if firID == "" {
+		year, _ := strconv.Atoi(req.FIRNumber[strings.IndexByte(req.FIRNumber, '/')+1:])
+		register, err := s.FIRRegister(ctx, FIRRegisterRequest{District: req.District, PoliceStation: req.PoliceStation, Year: year})
+		if err != nil {
+			return PublicVerification{}, err
+		}
+		i := slices.IndexFunc(register.Allocations, func(a FIRAllocation) bool {
+			return a.FIRNumber == req.FIRNumber && a.Status == "recorded"
+		})
+		if i < 0 {
+			return PublicVerification{}, nil
+		}
+		firID = register.Allocations[i].FIRID
+	}
+
+	fir, err := s.GetEFIR(ctx, firID)
+	if err != nil {
+		if translateFabricError(err).Status == http.StatusNotFound {
+			return PublicVerification{}, nil
+		}
+		return PublicVerification{}, err
+	}
+	if req.DocumentHash != "" && req.DocumentHash != fir.DocumentHash {
+		return PublicVerification{}, nil
+	}
+	return PublicVerification{Valid: true, AnchoredAt: fir.CreatedAt}, nil
+}
+
+// PublicVerifyHash checks that a SHA-256 is anchored as an evidence file or an e-FIR document
+func (ledgerService) PublicVerifyHash(ctx context.Context, hash string) (PublicVerification, error) {
+	var v fieldValidator
+	if v.sha256("hash", hash); len(v.errors) > 0 {
+		return PublicVerification{}, v.errors
+	}
+	result, err := evaluateTransaction(ctx, "FindHashAnchor", hash)
+	if err != nil {
+		return PublicVerification{}, err
+	}
+	anchor, err := decodeDocument[HashAnchor](result, "hash anchor")
+	if err != nil {
+		return PublicVerification{}, err
+	}
+	return PublicVerification{Valid: anchor.Anchored, AnchoredAt: anchor.AnchoredAt}, nil
+}
+
+// publicVerifyMiddleware limits the public verification portal per source IP. Unlike the API's
+// limiter it ignores API keys and tokens, which anonymous callers could vary to get fresh buckets
+// while guessing FIR numbers or hashes. The IP is the one setTrustedProxies resolves, so a forged
+// X-Forwarded-For does not buy a fresh bucket either.
+func publicVerifyMiddleware(ctx context.Context) gin.HandlerFunc {
+	limiter := newRateLimiter(getEnvInt("PUBLIC_VERIFY_PER_MINUTE", 10), getEnvInt("PUBLIC_VERIFY_BURST", 5))
+	log.Printf("🔎 Public verification enabled, %.0f/min (burst %.0f) per IP", limiter.rate*60, limiter.burst)
+	go evictIdleBuckets(ctx, limiter)
+
+	return func(c *gin.Context) {
+		if ok, wait := limiter.allow("ip:"+c.ClientIP(), time.Now()); !ok {
+			rejectRateLimited(c, wait)
+			return
+		}
+		// Answers change as documents are anchored, so shared caches must not keep a "not anchored"
+		c.Header("Cache-Control", "no-store")
+		c.Next()
+	}
+}
+
+func publicVerifyFIR(c *gin.Context) {
+	var req PublicFIRVerifyRequest
+	if !bindQuery(c, &req) {
+		return
+	}
+	verdict, err := ledger.PublicVerifyFIR(c.Request.Context(), req)
+	if err != nil {
+		respondServiceError(c, "Failed to verify the FIR", err)
+		return
+	}
+	respondData(c, http.StatusOK, verdict)
+}
+
+func publicVerifyHash(c *gin.Context) {
+	verdict, err := ledger.PublicVerifyHash(c.Request.Context(), strings.ToLower(c.Param("hash")))
+	if err != nil {
+		respondServiceError(c, "Failed to verify the hash", err)
+		return
+	}
+	respondData(c, http.StatusOK, verdict)
+}
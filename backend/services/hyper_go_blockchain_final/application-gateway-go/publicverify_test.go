@@ -0,0 +1,72 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestPublicFIRVerifyRequestValidation(t *testing.T) {
+	hash := strings.Repeat("ab", 32)
+	tests := []struct {
+		name  string
+		req   PublicFIRVerifyRequest
+		field string
+	}{
+		{"by ID", PublicFIRVerifyRequest{FIRID: "FIR-20250920T131910Z-a1b2c3", DocumentHash: hash}, ""},
+		{"by number", PublicFIRVerifyRequest{FIRNumber: "0042/2026", District: "East Khasi Hills", PoliceStation: "Shillong Sadar"}, ""},
+		{"nothing", PublicFIRVerifyRequest{DocumentHash: hash}, "firId"},
+		{"both", PublicFIRVerifyRequest{FIRID: "FIR-1", FIRNumber: "0042/2026"}, "firNumber"},
+		{"malformed number", PublicFIRVerifyRequest{FIRNumber: "42-2026", District: "East Khasi Hills", PoliceStation: "Shillong Sadar"}, "firNumber"},
+		{"number without station", PublicFIRVerifyRequest{FIRNumber: "0042/2026", District: "East Khasi Hills"}, "policeStation"},
+		{"uppercase hash", PublicFIRVerifyRequest{FIRID: "FIR-1", DocumentHash: strings.ToUpper(hash)}, "documentHash"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := tt.req.Validate()
+			if tt.field == "" && len(errs) > 0 {
+				t.Errorf("expected the request accepted, got %v", errs)
+			}
+			if tt.field != "" && (len(errs) != 1 || errs[0].Field != tt.field) {
+				t.Errorf("expected %s rejected, got %v", tt.field, errs)
+			}
+		})
+	}
+}
+
+func TestPublicVerifyRateLimit(t *testing.T) {
+	t.Setenv("PUBLIC_VERIFY_PER_MINUTE", "1")
+	t.Setenv("PUBLIC_VERIFY_BURST", "2")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r := gin.New()
+	setTrustedProxies(r)
+	r.GET("/public/verify/fir", publicVerifyMiddleware(ctx), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	send := func(ip string, i int) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/public/verify/fir", nil)
+		req.RemoteAddr = ip + ":40000"
+		// Neither a fresh API key nor a forged forwarding address per request buys a fresh bucket
+		req.Header.Set(apiKeyHeader, "guess-"+strconv.Itoa(i))
+		req.Header.Set("X-Forwarded-For", "192.0.2."+strconv.Itoa(i))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	for i := range 2 {
+		if w := send("203.0.113.7", i); w.Code != http.StatusOK || w.Header().Get("Cache-Control") != "no-store" {
+			t.Fatalf("request %d within burst answered %d", i+1, w.Code)
+		}
+	}
+	if w := send("203.0.113.7", 2); w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("expected the third request limited, got %d", w.Code)
+	}
+	if w := send("198.51.100.4", 3); w.Code != http.StatusOK {
+		t.Errorf("expected another IP unaffected, got %d", w.Code)
+	}
+}
@@ -0,0 +1,573 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+const (
+	pushDevicesKey = "sih:push:devices"
+
+	errCodePushDisabled = "PUSH_DISABLED"
+
+	maxPushTokenLength = 4096
+	maxPushZones       = 50
+)
+
+var (
+	pushPlatforms  = []string{"android", "ios", "web"}
+	severityLevels = []string{"low", "medium", "high", "critical"}
+)
+
+// RegisterDeviceRequest subscribes a dashboard or mobile app to push alerts
+type RegisterDeviceRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Platform string `json:"platform" binding:"required"`
+	// Zones limits alerts to SOS routed to these zones; empty subscribes to every zone
+	Zones []string `json:"zones"`
+	// DigitalID registers a tourist's app, which then only receives alerts addressed to the tourist
+	DigitalID string `json:"digitalID"`
+}
+
+func (r RegisterDeviceRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	if len(r.Token) > maxPushTokenLength {
+		v.add("token", "must be at most %d characters", maxPushTokenLength)
+	}
+	v.oneOf("platform", r.Platform, pushPlatforms)
+	if len(r.Zones) > maxPushZones {
+		v.add("zones", "must list at most %d zones", maxPushZones)
+	}
+	for i, zone := range r.Zones {
+		v.identifier(fmt.Sprintf("zones[%d]", i), zone)
+	}
+	if r.DigitalID != "" {
+		v.digitalID("digitalID", r.DigitalID)
+	}
+	return v.errors
+}
+
+// UnregisterDeviceRequest removes a device token
+type UnregisterDeviceRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// PushDevice is a registered device token
+type PushDevice struct {
+	Token        string   `json:"token"`
+	Platform     string   `json:"platform"`
+	Zones        []string `json:"zones"`
+	RegisteredAt string   `json:"registered_at"`
+	// Org is the organization that registered the device; it only receives that organization's alerts
+	Org string `json:"org,omitempty"`
+	// DigitalID is set for a tourist's app
+	DigitalID string `json:"digital_id,omitempty"`
+}
+
+// pushDeviceStore keeps device tokens in Redis when the document cache is configured, so every
+// gateway instance pushes to the same devices, and in memory otherwise
+type pushDeviceStore interface {
+	save(ctx context.Context, device PushDevice) error
+	remove(ctx context.Context, token string) error
+	list(ctx context.Context) ([]PushDevice, error)
+}
+
+// pushAlert is one alert to deliver to the devices subscribed to its zone. Alerts without a zone
+// go to every device of the owning organization. Alerts with tourists go only to those tourists'
+// apps, which receive no other alerts.
+type pushAlert struct {
+	key      string
+	zone     string
+	org      string
+	tourists map[string]bool
+	title    string
+	body     string
+	data     map[string]string
+}
+
+// pushNotifier turns chaincode events into FCM messages
+type pushNotifier struct {
+	store       pushDeviceStore
+	fcm         *fcmClient
+	minSeverity int
+	workers     int
+}
+
+var pusher *pushNotifier
+
+// initPush enables FCM delivery when FCM_PROJECT_ID is set. Runs after initDocumentCache so
+// device tokens can be shared through Redis.
+func initPush() {
+	project := getEnv("FCM_PROJECT_ID", "")
+	if project == "" {
+		log.Println("📲 FCM_PROJECT_ID not set, push notifications disabled")
+		return
+	}
+
+	minSeverity := getEnv("PUSH_MIN_SEVERITY", "high")
+	level := severityLevel(minSeverity)
+	if level < 0 {
+		panic(fmt.Errorf("PUSH_MIN_SEVERITY must be one of %s", strings.Join(severityLevels, ", ")))
+	}
+
+	var store pushDeviceStore = newMemoryPushStore()
+	backend := "memory"
+	if documentCache != nil {
+		store, backend = redisPushStore{documentCache}, "Redis"
+	}
+	httpClient := &http.Client{Timeout: getEnvDuration("FCM_TIMEOUT", 10*time.Second)}
+	pusher = &pushNotifier{
+		store: store,
+		fcm: &fcmClient{
+			endpoint:   strings.TrimSuffix(getEnv("FCM_ENDPOINT", "https://fcm.googleapis.com"), "/"),
+			project:    project,
+			tokens:     &gcpTokenSource{static: getEnv("GCP_ACCESS_TOKEN", ""), metadataHost: getEnv("GCE_METADATA_HOST", "metadata.google.internal"), httpClient: httpClient},
+			httpClient: httpClient,
+		},
+		minSeverity: level,
+		workers:     getEnvInt("PUSH_WORKERS", 8),
+	}
+	log.Printf("📲 Pushing %s+ incidents and SOS alerts through FCM project %s, devices kept in %s", minSeverity, project, backend)
+}
+
+func severityLevel(severity string) int {
+	for i, level := range severityLevels {
+		if severity == level {
+			return i
+		}
+	}
+	return -1
+}
+
+// pushFromEvent pushes alerts for new SOS and high-severity incidents, and tells tourists when an
+// insurer registers a claim referencing their incident or a consent receipt is issued to them.
+// Only events from the live stream reach it, so a restarted gateway does not push old alerts again.
+func pushFromEvent(ctx context.Context, event *client.ChaincodeEvent) {
+	if pusher == nil {
+		return
+	}
+	alert, ok := pusher.alertFor(event)
+	if !ok {
+		return
+	}
+	go pusher.deliver(context.WithoutCancel(ctx), alert)
+}
+
+func (p *pushNotifier) alertFor(event *client.ChaincodeEvent) (pushAlert, bool) {
+	key := event.TransactionID + ":" + event.EventName
+	switch event.EventName {
+	case "RaiseSOS":
+		var sos struct {
+			AlertID    string `json:"alert_id"`
+			DigitalID  string `json:"digital_id"`
+			PoliceUnit string `json:"police_unit"`
+			RaisedAt   string `json:"raised_at"`
+			OwnerOrg   string `json:"owner_org"`
+		}
+		if err := json.Unmarshal(event.Payload, &sos); err != nil {
+			return pushAlert{}, false
+		}
+		alert := pushAlert{
+			key:   key,
+			org:   sos.OwnerOrg,
+			title: "SOS raised",
+			body:  "Tourist " + sos.DigitalID + " raised an SOS",
+			data:  map[string]string{"type": "sos", "alert_id": sos.AlertID, "digital_id": sos.DigitalID, "raised_at": sos.RaisedAt},
+		}
+		if unit := policeUnitByID(sos.PoliceUnit); unit != nil {
+			alert.zone = unit.zone()
+			alert.body += ", assigned to " + unit.Name
+			alert.data["police_unit"] = unit.ID
+		}
+		return alert, true
+
+	case "CreateIncident":
+		var incident IncidentDocument
+		if err := json.Unmarshal(event.Payload, &incident); err != nil || severityLevel(incident.Severity) < p.minSeverity {
+			return pushAlert{}, false
+		}
+		return pushAlert{
+			key:   key,
+			org:   incident.OwnerOrg,
+			title: strings.ToUpper(incident.Severity[:1]) + incident.Severity[1:] + " severity incident",
+			body:  "Incident " + incident.IncidentID + " reported by " + incident.Reporter,
+			data:  map[string]string{"type": "incident", "incident_id": incident.IncidentID, "severity": incident.Severity, "created_at": incident.CreatedAt},
+		}, true
+
+	case "RegisterClaimReference":
+		var claim ClaimReference
+		if err := json.Unmarshal(event.Payload, &claim); err != nil || claim.DigitalID == "" {
+			return pushAlert{}, false
+		}
+		return pushAlert{
+			key:      key,
+			tourists: map[string]bool{claim.DigitalID: true},
+			title:    "Insurance claim registered",
+			body:     insurerName(claim.InsurerID) + " registered a claim referencing incident " + claim.IncidentID,
+			data:     map[string]string{"type": "claim_reference", "claim_ref_id": claim.ClaimRefID, "incident_id": claim.IncidentID, "insurer_id": claim.InsurerID, "registered_at": claim.RegisteredAt},
+		}, true
+
+	case "RecordConsentReceipt":
+		var receipt ConsentReceiptDocument
+		if err := json.Unmarshal(event.Payload, &receipt); err != nil || receipt.DigitalID == "" {
+			return pushAlert{}, false
+		}
+		return pushAlert{
+			key:      key,
+			tourists: map[string]bool{receipt.DigitalID: true},
+			title:    "Consent receipt",
+			body:     "Your receipt for the " + receipt.Action + " of your " + strings.ReplaceAll(receipt.Purpose, "_", " ") + " consent is ready",
+			data:     map[string]string{"type": "consent_receipt", "receipt_id": receipt.ReceiptID, "consent_id": receipt.ConsentID, "receipt_hash": receipt.ReceiptHash, "issued_at": receipt.IssuedAt},
+		}, true
+	}
+	return pushAlert{}, false
+}
+
+// deliver sends the alert to every subscribed device, PUSH_WORKERS at a time. Tokens FCM reports
+// as unregistered are removed.
+func (p *pushNotifier) deliver(ctx context.Context, alert pushAlert) {
+	// With several gateway instances each one sees the event; the first to claim it pushes
+	if first, err := claimEvent(ctx, "push:"+alert.key, 24*time.Hour); err != nil || !first {
+		if err != nil {
+			log.Printf("📲 Failed to claim push for %s: %v", alert.key, err)
+		}
+		return
+	}
+	devices, err := p.store.list(ctx)
+	if err != nil {
+		log.Printf("📲 Failed to list push devices: %v", err)
+		return
+	}
+
+	queue := make(chan PushDevice)
+	var wg sync.WaitGroup
+	for range max(p.workers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for device := range queue {
+				err := p.fcm.send(ctx, device, alert)
+				if errors.Is(err, errFCMUnregistered) {
+					if err := p.store.remove(ctx, device.Token); err != nil {
+						log.Printf("📲 Failed to remove unregistered device: %v", err)
+					}
+				} else if err != nil {
+					log.Printf("📲 Failed to push %s to %s device: %v", alert.key, device.Platform, err)
+				}
+			}
+		}()
+	}
+	for _, device := range devices {
+		if device.addressed(alert) {
+			queue <- device
+		}
+	}
+	close(queue)
+	wg.Wait()
+}
+
+// addressed reports whether an alert goes to the device. Tourists' apps only receive alerts
+// addressed to their tourist.
+func (d PushDevice) addressed(alert pushAlert) bool {
+	if alert.tourists != nil {
+		return alert.tourists[d.DigitalID]
+	}
+	return d.DigitalID == "" && d.subscribed(alert.zone) && d.receives(alert.org)
+}
+
+// receives reports whether the device's organization may see an alert owned by org. Devices
+// registered before tenancy was enabled belong to the legacy organization.
+func (d PushDevice) receives(org string) bool {
+	if !tenancy.enabled {
+		return true
+	}
+	owner := d.Org
+	if owner == "" {
+		owner = tenancy.legacyOrg
+	}
+	return tenant{org: owner, all: tenancy.adminOrgs[owner]}.owns(org)
+}
+
+func (d PushDevice) subscribed(zone string) bool {
+	if zone == "" || len(d.Zones) == 0 {
+		return true
+	}
+	for _, z := range d.Zones {
+		if z == zone {
+			return true
+		}
+	}
+	return false
+}
+
+var errFCMUnregistered = errors.New("device token is no longer registered")
+
+// fcmClient sends messages with the FCM HTTP v1 API
+type fcmClient struct {
+	endpoint   string
+	project    string
+	tokens     *gcpTokenSource
+	httpClient *http.Client
+}
+
+func (f *fcmClient) send(ctx context.Context, device PushDevice, alert pushAlert) error {
+	message := map[string]interface{}{
+		"token":        device.Token,
+		"notification": map[string]string{"title": alert.title, "body": alert.body},
+		"data":         alert.data,
+	}
+	// Alerts must wake the device, so they go at the highest priority each platform offers
+	switch device.Platform {
+	case "android":
+		message["android"] = map[string]string{"priority": "high"}
+	case "ios":
+		message["apns"] = map[string]interface{}{"headers": map[string]string{"apns-priority": "10"}}
+	case "web":
+		message["webpush"] = map[string]interface{}{"headers": map[string]string{"Urgency": "high"}}
+	}
+	body, err := json.Marshal(map[string]interface{}{"message": message})
+	if err != nil {
+		return err
+	}
+
+	token, err := f.tokens.token(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get access token: %w", err)
+	}
+	target := fmt.Sprintf("%s/v1/projects/%s/messages:send", f.endpoint, url.PathEscape(f.project))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := f.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	var out struct {
+		Error struct {
+			Message string `json:"message"`
+			Details []struct {
+				ErrorCode string `json:"errorCode"`
+			} `json:"details"`
+		} `json:"error"`
+	}
+	json.Unmarshal(data, &out)
+	for _, detail := range out.Error.Details {
+		if detail.ErrorCode == "UNREGISTERED" {
+			return errFCMUnregistered
+		}
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return errFCMUnregistered
+	}
+	return fmt.Errorf("FCM responded with %d: %s", resp.StatusCode, out.Error.Message)
+}
+
+func pushTokenKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// redisPushStore keeps devices in a hash keyed by a digest of the token
+type redisPushStore struct {
+	redis *redisClient
+}
+
+func (s redisPushStore) save(ctx context.Context, device PushDevice) error {
+	data, err := json.Marshal(device)
+	if err != nil {
+		return err
+	}
+	_, err = s.redis.Do(ctx, "HSET", pushDevicesKey, pushTokenKey(device.Token), string(data))
+	return err
+}
+
+func (s redisPushStore) remove(ctx context.Context, token string) error {
+	_, err := s.redis.Do(ctx, "HDEL", pushDevicesKey, pushTokenKey(token))
+	return err
+}
+
+func (s redisPushStore) list(ctx context.Context) ([]PushDevice, error) {
+	reply, err := s.redis.Do(ctx, "HVALS", pushDevicesKey)
+	if err != nil {
+		return nil, err
+	}
+	items, _ := reply.([]interface{})
+	devices := make([]PushDevice, 0, len(items))
+	for _, item := range items {
+		data, _ := item.([]byte)
+		var device PushDevice
+		if err := json.Unmarshal(data, &device); err == nil {
+			devices = append(devices, device)
+		}
+	}
+	return devices, nil
+}
+
+// memoryPushStore serves a single gateway instance
+type memoryPushStore struct {
+	mu      sync.Mutex
+	devices map[string]PushDevice
+}
+
+func newMemoryPushStore() *memoryPushStore {
+	return &memoryPushStore{devices: map[string]PushDevice{}}
+}
+
+func (s *memoryPushStore) save(_ context.Context, device PushDevice) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.devices[pushTokenKey(device.Token)] = device
+	return nil
+}
+
+func (s *memoryPushStore) remove(_ context.Context, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.devices, pushTokenKey(token))
+	return nil
+}
+
+func (s *memoryPushStore) list(context.Context) ([]PushDevice, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	devices := make([]PushDevice, 0, len(s.devices))
+	for _, device := range s.devices {
+		devices = append(devices, device)
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].RegisteredAt < devices[j].RegisteredAt })
+	return devices, nil
+}
+
+func pushDisabled(c *gin.Context) bool {
+	if pusher == nil {
+		respondError(c, http.StatusServiceUnavailable, errCodePushDisabled, "Push notifications are not configured")
+		return true
+	}
+	return false
+}
+
+func registerPushDevice(c *gin.Context) {
+	if pushDisabled(c) {
+		return
+	}
+	var req RegisterDeviceRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+
+	device := PushDevice{
+		Token:        req.Token,
+		Platform:     req.Platform,
+		Zones:        req.Zones,
+		RegisteredAt: time.Now().UTC().Format(time.RFC3339),
+		Org:          tenantFromContext(c.Request.Context()).org,
+		DigitalID:    req.DigitalID,
+	}
+	if device.Zones == nil {
+		device.Zones = []string{}
+	}
+	if err := pusher.store.save(c.Request.Context(), device); err != nil {
+		respondServiceError(c, "Failed to register device", err)
+		return
+	}
+	respondData(c, http.StatusCreated, device)
+}
+
+func unregisterPushDevice(c *gin.Context) {
+	if pushDisabled(c) {
+		return
+	}
+	var req UnregisterDeviceRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+
+	if err := pusher.store.remove(c.Request.Context(), req.Token); err != nil {
+		respondServiceError(c, "Failed to unregister device", err)
+		return
+	}
+	respondData(c, http.StatusOK, gin.H{"message": "Device unregistered successfully"})
+}
+
+// gcpTokenSource supplies OAuth access tokens: GCP_ACCESS_TOKEN when set, otherwise the service
+// account token from the GCE/GKE metadata server, cached until shortly before it expires
+type gcpTokenSource struct {
+	static       string
+	metadataHost string
+	httpClient   *http.Client
+
+	mu      sync.Mutex
+	current string
+	expires time.Time
+}
+
+func (ts *gcpTokenSource) token(ctx context.Context) (string, error) {
+	if ts.static != "" {
+		return ts.static, nil
+	}
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.current != "" && time.Now().Before(ts.expires) {
+		return ts.current, nil
+	}
+
+	u := url.URL{Scheme: "http", Host: ts.metadataHost, Path: "/computeMetadata/v1/instance/service-accounts/default/token"}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := ts.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return "", fmt.Errorf("metadata server responded with %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var out struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return "", err
+	}
+	ts.current = out.AccessToken
+	ts.expires = time.Now().Add(time.Duration(out.ExpiresIn)*time.Second - time.Minute)
+	return ts.current, nil
+}
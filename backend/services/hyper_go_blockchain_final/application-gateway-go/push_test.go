@@ -0,0 +1,91 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+func TestPushAlertsByZone(t *testing.T) {
+	var mu sync.Mutex
+	var pushed []string
+	fcm := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Message struct {
+				Token string            `json:"token"`
+				Data  map[string]string `json:"data"`
+			} `json:"message"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if r.URL.Path != "/v1/projects/sih/messages:send" || r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if body.Message.Token == "stale" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":{"status":"NOT_FOUND","details":[{"errorCode":"UNREGISTERED"}]}}`))
+			return
+		}
+		mu.Lock()
+		pushed = append(pushed, body.Message.Token+":"+body.Message.Data["type"])
+		mu.Unlock()
+	}))
+	defer fcm.Close()
+
+	policeUnits = []PoliceUnit{{ID: "PS-1", Name: "Sadar", Zone: "shillong"}}
+	defer func() { policeUnits = nil }()
+	store := newMemoryPushStore()
+	ctx := context.Background()
+	for _, device := range []PushDevice{
+		{Token: "shillong", Platform: "android", Zones: []string{"shillong"}},
+		{Token: "guwahati", Platform: "ios", Zones: []string{"guwahati"}},
+		{Token: "control-room", Platform: "web"},
+		{Token: "stale", Platform: "android"},
+		{Token: "tourist-app", Platform: "android", DigitalID: "did:sih:1"},
+	} {
+		store.save(ctx, device)
+	}
+	p := &pushNotifier{
+		store:       store,
+		fcm:         &fcmClient{endpoint: fcm.URL, project: "sih", tokens: &gcpTokenSource{static: "token"}, httpClient: http.DefaultClient},
+		minSeverity: severityLevel("high"),
+		workers:     2,
+	}
+
+	events := []*client.ChaincodeEvent{
+		{TransactionID: "tx1", EventName: "RaiseSOS", Payload: []byte(`{"alert_id":"SOS-1","digital_id":"did:sih:1","police_unit":"PS-1"}`)},
+		{TransactionID: "tx2", EventName: "CreateIncident", Payload: []byte(`{"incident_id":"inc-1","severity":"critical"}`)},
+		{TransactionID: "tx3", EventName: "CreateIncident", Payload: []byte(`{"incident_id":"inc-2","severity":"medium"}`)},
+		{TransactionID: "tx4", EventName: "UpdateDID", Payload: []byte(`{"digital_id":"did:sih:1"}`)},
+		{TransactionID: "tx5", EventName: "RegisterClaimReference", Payload: []byte(`{"claim_ref_id":"CLAIM-1","incident_id":"inc-1","digital_id":"did:sih:1","insurer_id":"acko"}`)},
+		{TransactionID: "tx6", EventName: "RecordConsentReceipt", Payload: []byte(`{"receipt_id":"CR:CONSENT:did:sih:1:family_sharing:v2","digital_id":"did:sih:1","purpose":"family_sharing","action":"withdraw"}`)},
+	}
+	for _, event := range events {
+		if alert, ok := p.alertFor(event); ok {
+			p.deliver(ctx, alert)
+		}
+	}
+
+	sort.Strings(pushed)
+	want := []string{"control-room:incident", "control-room:sos", "guwahati:incident", "shillong:incident", "shillong:sos", "tourist-app:claim_reference", "tourist-app:consent_receipt"}
+	if len(pushed) != len(want) {
+		t.Fatalf("expected pushes %v, got %v", want, pushed)
+	}
+	for i := range want {
+		if pushed[i] != want[i] {
+			t.Fatalf("expected pushes %v, got %v", want, pushed)
+		}
+	}
+	devices, _ := store.list(ctx)
+	for _, device := range devices {
+		if device.Token == "stale" {
+			t.Error("expected the unregistered token to be removed")
+		}
+	}
+}
@@ -0,0 +1,476 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+)
+
+// qrBlockSpec is the error correction layout of one QR version at level M
+type qrBlockSpec struct {
+	ecPerBlock            int
+	group1Blocks, group1N int
+	group2Blocks, group2N int
+}
+
+// qrLevelM covers versions 1-20, enough for about 660 bytes, which is far more than a signed DID payload needs
+var qrLevelM = []qrBlockSpec{
+	{10, 1, 16, 0, 0}, {16, 1, 28, 0, 0}, {26, 1, 44, 0, 0}, {18, 2, 32, 0, 0}, {24, 2, 43, 0, 0},
+	{16, 4, 27, 0, 0}, {18, 4, 31, 0, 0}, {22, 2, 38, 2, 39}, {22, 3, 36, 2, 37}, {26, 4, 43, 1, 44},
+	{30, 1, 50, 4, 51}, {22, 6, 36, 2, 37}, {22, 8, 37, 1, 38}, {24, 4, 40, 5, 41}, {24, 5, 41, 5, 42},
+	{28, 7, 45, 3, 46}, {28, 10, 46, 1, 47}, {26, 9, 43, 4, 44}, {26, 3, 44, 11, 45}, {26, 3, 41, 13, 42},
+}
+
+var errQRTooLong = errors.New("data too long for a QR code")
+
+// qrCode is an encoded QR symbol; modules[y][x] is true for dark modules
+type qrCode struct {
+	version  int
+	size     int
+	mask     int
+	modules  [][]bool
+	function [][]bool
+}
+
+func (spec qrBlockSpec) dataCodewords() int {
+	return spec.group1Blocks*spec.group1N + spec.group2Blocks*spec.group2N
+}
+
+// encodeQR encodes data in byte mode at error correction level M, choosing the smallest version
+// and the mask with the lowest penalty score
+func encodeQR(data []byte) (*qrCode, error) {
+	version := 0
+	for v := 1; v <= len(qrLevelM); v++ {
+		countBits := 8
+		if v >= 10 {
+			countBits = 16
+		}
+		if 4+countBits+len(data)*8 <= qrLevelM[v-1].dataCodewords()*8 {
+			version = v
+			break
+		}
+	}
+	if version == 0 {
+		return nil, errQRTooLong
+	}
+
+	qr := &qrCode{version: version, size: version*4 + 17}
+	qr.modules = make([][]bool, qr.size)
+	qr.function = make([][]bool, qr.size)
+	for i := range qr.modules {
+		qr.modules[i] = make([]bool, qr.size)
+		qr.function[i] = make([]bool, qr.size)
+	}
+
+	qr.drawFunctionPatterns()
+	qr.drawCodewords(qr.interleave(qr.dataCodewords(data)))
+
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		qr.applyMask(mask)
+		qr.drawFormatBits(mask)
+		if penalty := qr.penalty(); bestPenalty < 0 || penalty < bestPenalty {
+			best, bestPenalty = mask, penalty
+		}
+		qr.applyMask(mask)
+	}
+	qr.mask = best
+	qr.applyMask(best)
+	qr.drawFormatBits(best)
+	return qr, nil
+}
+
+// dataCodewords builds the mode indicator, length, payload, terminator and padding
+func (qr *qrCode) dataCodewords(data []byte) []byte {
+	var bits []bool
+	appendBits := func(value, n int) {
+		for i := n - 1; i >= 0; i-- {
+			bits = append(bits, (value>>i)&1 == 1)
+		}
+	}
+
+	capacity := qrLevelM[qr.version-1].dataCodewords() * 8
+	appendBits(0x4, 4)
+	if qr.version >= 10 {
+		appendBits(len(data), 16)
+	} else {
+		appendBits(len(data), 8)
+	}
+	for _, b := range data {
+		appendBits(int(b), 8)
+	}
+	appendBits(0, min(4, capacity-len(bits)))
+	appendBits(0, (8-len(bits)%8)%8)
+
+	codewords := make([]byte, 0, capacity/8)
+	for i := 0; i < len(bits); i += 8 {
+		var b byte
+		for j := 0; j < 8; j++ {
+			if bits[i+j] {
+				b |= 1 << (7 - j)
+			}
+		}
+		codewords = append(codewords, b)
+	}
+	for pad := byte(0xEC); len(codewords) < capacity/8; pad ^= 0xEC ^ 0x11 {
+		codewords = append(codewords, pad)
+	}
+	return codewords
+}
+
+// interleave splits data into blocks, appends Reed-Solomon error correction and interleaves the result
+func (qr *qrCode) interleave(data []byte) []byte {
+	spec := qrLevelM[qr.version-1]
+	generator := reedSolomonGenerator(spec.ecPerBlock)
+
+	var blocks, ecBlocks [][]byte
+	offset := 0
+	for i := 0; i < spec.group1Blocks+spec.group2Blocks; i++ {
+		n := spec.group1N
+		if i >= spec.group1Blocks {
+			n = spec.group2N
+		}
+		block := data[offset : offset+n]
+		offset += n
+		blocks = append(blocks, block)
+		ecBlocks = append(ecBlocks, reedSolomonRemainder(block, generator))
+	}
+
+	var result []byte
+	for i := 0; i < max(spec.group1N, spec.group2N); i++ {
+		for _, block := range blocks {
+			if i < len(block) {
+				result = append(result, block[i])
+			}
+		}
+	}
+	for i := 0; i < spec.ecPerBlock; i++ {
+		for _, block := range ecBlocks {
+			result = append(result, block[i])
+		}
+	}
+	return result
+}
+
+func (qr *qrCode) setFunction(x, y int, dark bool) {
+	qr.modules[y][x] = dark
+	qr.function[y][x] = true
+}
+
+func (qr *qrCode) drawFunctionPatterns() {
+	for i := 0; i < qr.size; i++ {
+		qr.setFunction(6, i, i%2 == 0)
+		qr.setFunction(i, 6, i%2 == 0)
+	}
+
+	qr.drawFinder(3, 3)
+	qr.drawFinder(qr.size-4, 3)
+	qr.drawFinder(3, qr.size-4)
+
+	positions := qrAlignmentPositions(qr.version)
+	last := len(positions) - 1
+	for i, x := range positions {
+		for j, y := range positions {
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					qr.setFunction(x+dx, y+dy, max(abs(dx), abs(dy)) != 1)
+				}
+			}
+		}
+	}
+
+	// Reserve the format areas; the real bits are drawn once the mask is chosen
+	qr.drawFormatBits(0)
+
+	if qr.version >= 7 {
+		rem := qr.version
+		for i := 0; i < 12; i++ {
+			rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+		}
+		bits := qr.version<<12 | rem
+		for i := 0; i < 18; i++ {
+			dark := (bits>>i)&1 == 1
+			a, b := qr.size-11+i%3, i/3
+			qr.setFunction(a, b, dark)
+			qr.setFunction(b, a, dark)
+		}
+	}
+}
+
+func (qr *qrCode) drawFinder(cx, cy int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			x, y := cx+dx, cy+dy
+			if x < 0 || x >= qr.size || y < 0 || y >= qr.size {
+				continue
+			}
+			dist := max(abs(dx), abs(dy))
+			qr.setFunction(x, y, dist != 2 && dist != 4)
+		}
+	}
+}
+
+// drawFormatBits writes the level M indicator and mask, BCH protected, in both format areas
+func (qr *qrCode) drawFormatBits(mask int) {
+	data := 0<<3 | mask // level M is encoded as 00
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool { return (bits>>i)&1 == 1 }
+
+	for i := 0; i <= 5; i++ {
+		qr.setFunction(8, i, bit(i))
+	}
+	qr.setFunction(8, 7, bit(6))
+	qr.setFunction(8, 8, bit(7))
+	qr.setFunction(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		qr.setFunction(14-i, 8, bit(i))
+	}
+
+	for i := 0; i < 8; i++ {
+		qr.setFunction(qr.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		qr.setFunction(8, qr.size-15+i, bit(i))
+	}
+	qr.setFunction(8, qr.size-8, true)
+}
+
+// drawCodewords places codewords in the two-column zigzag from the bottom right corner
+func (qr *qrCode) drawCodewords(codewords []byte) {
+	i := 0
+	for right := qr.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		upward := (right+1)&2 == 0
+		for vert := 0; vert < qr.size; vert++ {
+			for j := 0; j < 2; j++ {
+				x, y := right-j, vert
+				if upward {
+					y = qr.size - 1 - vert
+				}
+				if !qr.function[y][x] && i < len(codewords)*8 {
+					qr.modules[y][x] = (codewords[i>>3]>>(7-i&7))&1 == 1
+					i++
+				}
+			}
+		}
+	}
+}
+
+// applyMask XORs a mask pattern over the data modules; applying it twice undoes it
+func (qr *qrCode) applyMask(mask int) {
+	for y := 0; y < qr.size; y++ {
+		for x := 0; x < qr.size; x++ {
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if invert && !qr.function[y][x] {
+				qr.modules[y][x] = !qr.modules[y][x]
+			}
+		}
+	}
+}
+
+// penalty scores a masked symbol using the four rules from ISO/IEC 18004
+func (qr *qrCode) penalty() int {
+	result, dark := 0, 0
+	finderLike := [][]bool{
+		{true, false, true, true, true, false, true, false, false, false, false},
+		{false, false, false, false, true, false, true, true, true, false, true},
+	}
+
+	for _, horizontal := range []bool{true, false} {
+		at := func(line, i int) bool {
+			if horizontal {
+				return qr.modules[line][i]
+			}
+			return qr.modules[i][line]
+		}
+		for line := 0; line < qr.size; line++ {
+			run := 1
+			for i := 1; i <= qr.size; i++ {
+				if i < qr.size && at(line, i) == at(line, i-1) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					result += 3 + run - 5
+				}
+				run = 1
+			}
+			for i := 0; i+11 <= qr.size; i++ {
+				for _, pattern := range finderLike {
+					match := true
+					for k, want := range pattern {
+						if at(line, i+k) != want {
+							match = false
+							break
+						}
+					}
+					if match {
+						result += 40
+					}
+				}
+			}
+		}
+	}
+
+	for y := 0; y < qr.size; y++ {
+		for x := 0; x < qr.size; x++ {
+			if qr.modules[y][x] {
+				dark++
+			}
+			if x+1 < qr.size && y+1 < qr.size {
+				c := qr.modules[y][x]
+				if c == qr.modules[y][x+1] && c == qr.modules[y+1][x] && c == qr.modules[y+1][x+1] {
+					result += 3
+				}
+			}
+		}
+	}
+
+	total := qr.size * qr.size
+	k := (abs(dark*20-total*10)+total-1)/total - 1
+	return result + k*10
+}
+
+func qrAlignmentPositions(version int) []int {
+	if version == 1 {
+		return nil
+	}
+	count := version/7 + 2
+	step := (version*4 + count*2 + 1) / (count*2 - 2) * 2
+	if version == 32 {
+		step = 26
+	}
+	positions := make([]int, count)
+	positions[0] = 6
+	for i, pos := count-1, version*4+17-7; i >= 1; i, pos = i-1, pos-step {
+		positions[i] = pos
+	}
+	return positions
+}
+
+func reedSolomonGenerator(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = gfMultiply(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMultiply(root, 0x02)
+	}
+	return result
+}
+
+func reedSolomonRemainder(data, generator []byte) []byte {
+	result := make([]byte, len(generator))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i := range result {
+			result[i] ^= gfMultiply(generator[i], factor)
+		}
+	}
+	return result
+}
+
+// gfMultiply multiplies in GF(2^8) modulo the QR polynomial x^8 + x^4 + x^3 + x^2 + 1
+func gfMultiply(x, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11D)
+		z ^= int((y>>i)&1) * int(x)
+	}
+	return byte(z)
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// qrQuietZone is the light border, in modules, that scanners need around the symbol
+const qrQuietZone = 4
+
+// PNG renders the symbol with each module scale pixels wide
+func (qr *qrCode) PNG(scale int) ([]byte, error) {
+	side := (qr.size + 2*qrQuietZone) * scale
+	img := image.NewPaletted(image.Rect(0, 0, side, side), color.Palette{color.White, color.Black})
+	for y := 0; y < qr.size; y++ {
+		for x := 0; x < qr.size; x++ {
+			if !qr.modules[y][x] {
+				continue
+			}
+			for py := 0; py < scale; py++ {
+				row := img.Pix[((y+qrQuietZone)*scale+py)*img.Stride:]
+				for px := 0; px < scale; px++ {
+					row[(x+qrQuietZone)*scale+px] = 1
+				}
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// SVG renders the symbol as a scalable image with one path for all dark modules
+func (qr *qrCode) SVG() []byte {
+	side := qr.size + 2*qrQuietZone
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, side, side)
+	fmt.Fprintf(&buf, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, side, side)
+	for y := 0; y < qr.size; y++ {
+		for x := 0; x < qr.size; x++ {
+			if qr.modules[y][x] {
+				fmt.Fprintf(&buf, "M%d %dh1v1h-1z", x+qrQuietZone, y+qrQuietZone)
+			}
+		}
+	}
+	buf.WriteString(`"/></svg>`)
+	return buf.Bytes()
+}
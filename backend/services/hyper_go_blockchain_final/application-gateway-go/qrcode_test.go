@@ -0,0 +1,199 @@
+package gateway
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"strings"
+	"testing"
+)
+
+func TestReedSolomonKnownVector(t *testing.T) {
+	// "HELLO WORLD" at 1-M from the worked example in the QR specification tutorials
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	want := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+	if got := reedSolomonRemainder(data, reedSolomonGenerator(10)); !bytes.Equal(got, want) {
+		t.Fatalf("EC codewords = %v, want %v", got, want)
+	}
+}
+
+func TestEncodeQRRoundTrip(t *testing.T) {
+	for _, payload := range []string{"did:sih:tourist001", strings.Repeat("SIH1.payload-", 30)} {
+		qr, err := encodeQR([]byte(payload))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := decodeQRForTest(t, qr); got != payload {
+			t.Errorf("version %d decoded %q, want %q", qr.version, got, payload)
+		}
+	}
+
+	if _, err := encodeQR(make([]byte, 1000)); err != errQRTooLong {
+		t.Errorf("err = %v, want errQRTooLong", err)
+	}
+}
+
+// decodeQRForTest reads a symbol back independently of the encoder's intermediate state
+func decodeQRForTest(t *testing.T, qr *qrCode) string {
+	t.Helper()
+	for _, corner := range [][2]int{{0, 0}, {qr.size - 7, 0}, {0, qr.size - 7}} {
+		for i := 0; i < 7; i++ {
+			if !qr.modules[corner[1]][corner[0]+i] || !qr.modules[corner[1]+i][corner[0]] {
+				t.Fatalf("finder pattern missing at %v", corner)
+			}
+		}
+	}
+
+	format := 0
+	for i := 0; i <= 5; i++ {
+		if qr.modules[i][8] {
+			format |= 1 << i
+		}
+	}
+	for i, pos := range [][2]int{{8, 7}, {8, 8}, {7, 8}} {
+		if qr.modules[pos[1]][pos[0]] {
+			format |= 1 << (6 + i)
+		}
+	}
+	for i := 9; i < 15; i++ {
+		if qr.modules[8][14-i] {
+			format |= 1 << i
+		}
+	}
+	format ^= 0x5412
+	rem := format
+	for i := 14; i >= 10; i-- {
+		if rem>>i&1 == 1 {
+			rem ^= 0x537 << (i - 10)
+		}
+	}
+	if rem != 0 || format>>13 != 0 {
+		t.Fatalf("format bits %015b are not a level M BCH codeword", format)
+	}
+	mask := format >> 10 & 7
+
+	// Rebuild the function pattern map from a blank symbol of the same version
+	blank := &qrCode{version: qr.version, size: qr.size}
+	blank.modules = make([][]bool, qr.size)
+	blank.function = make([][]bool, qr.size)
+	for i := range blank.modules {
+		blank.modules[i] = make([]bool, qr.size)
+		blank.function[i] = make([]bool, qr.size)
+	}
+	blank.drawFunctionPatterns()
+
+	var bits []bool
+	for right := qr.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < qr.size; vert++ {
+			for j := 0; j < 2; j++ {
+				x, y := right-j, vert
+				if (right+1)&2 == 0 {
+					y = qr.size - 1 - vert
+				}
+				if blank.function[y][x] {
+					continue
+				}
+				masked := qr.modules[y][x]
+				switch mask {
+				case 0:
+					masked = masked != ((x+y)%2 == 0)
+				case 1:
+					masked = masked != (y%2 == 0)
+				case 2:
+					masked = masked != (x%3 == 0)
+				case 3:
+					masked = masked != ((x+y)%3 == 0)
+				case 4:
+					masked = masked != ((x/3+y/2)%2 == 0)
+				case 5:
+					masked = masked != (x*y%2+x*y%3 == 0)
+				case 6:
+					masked = masked != ((x*y%2+x*y%3)%2 == 0)
+				case 7:
+					masked = masked != (((x+y)%2+x*y%3)%2 == 0)
+				}
+				bits = append(bits, masked)
+			}
+		}
+	}
+	codewords := make([]byte, len(bits)/8)
+	for i := range codewords {
+		for j := 0; j < 8; j++ {
+			if bits[i*8+j] {
+				codewords[i] |= 1 << (7 - j)
+			}
+		}
+	}
+
+	spec := qrLevelM[qr.version-1]
+	blockCount := spec.group1Blocks + spec.group2Blocks
+	blocks := make([][]byte, blockCount)
+	pos := 0
+	for i := 0; i < max(spec.group1N, spec.group2N); i++ {
+		for b := range blocks {
+			if (b < spec.group1Blocks && i < spec.group1N) || (b >= spec.group1Blocks && i < spec.group2N) {
+				blocks[b] = append(blocks[b], codewords[pos])
+				pos++
+			}
+		}
+	}
+	var data []byte
+	for b := range blocks {
+		dataLen := len(blocks[b])
+		for i := 0; i < spec.ecPerBlock; i++ {
+			blocks[b] = append(blocks[b], codewords[pos+i*blockCount+b])
+		}
+		if rem := reedSolomonRemainder(blocks[b], reedSolomonGenerator(spec.ecPerBlock)); !bytes.Equal(rem, make([]byte, spec.ecPerBlock)) {
+			t.Fatalf("block %d fails its Reed-Solomon check", b)
+		}
+		data = append(data, blocks[b][:dataLen]...)
+	}
+
+	if data[0]>>4 != 0x4 {
+		t.Fatalf("mode %x, want byte mode", data[0]>>4)
+	}
+	var length, offset int
+	if qr.version >= 10 {
+		length, offset = int(data[0]&0xF)<<12|int(data[1])<<4|int(data[2]>>4), 20
+	} else {
+		length, offset = int(data[0]&0xF)<<4|int(data[1]>>4), 12
+	}
+	out := make([]byte, length)
+	for i := range out {
+		bit := offset + i*8
+		out[i] = data[bit/8]<<(bit%8) | data[bit/8+1]>>(8-bit%8)
+	}
+	return string(out)
+}
+
+func TestQRSignerRoundTrip(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := newQRSigner(key)
+
+	payload, err := signer.sign(DIDQRClaims{DigitalID: "did:sih:tourist001", Issuer: "tourism_authority", ExpiresAt: "2030-01-01T00:00:00Z"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := signer.verify(payload)
+	if err != nil || claims.DigitalID != "did:sih:tourist001" || claims.KeyID != signer.keyID {
+		t.Fatalf("verify = %+v, %v", claims, err)
+	}
+
+	tampered := strings.Replace(payload, "SIH1.", "SIH1.e", 1)
+	if _, err := signer.verify(tampered); err == nil {
+		t.Error("tampered payload verified")
+	}
+	_, other, _ := ed25519.GenerateKey(nil)
+	forged, _ := newQRSigner(other).sign(DIDQRClaims{DigitalID: "did:sih:tourist001"})
+	if _, err := signer.verify(forged); err != errQRUnknownKey {
+		t.Errorf("forged payload err = %v, want errQRUnknownKey", err)
+	}
+	if _, err := signer.verify("not a payload"); err != errQRMalformed {
+		t.Errorf("garbage err = %v, want errQRMalformed", err)
+	}
+}
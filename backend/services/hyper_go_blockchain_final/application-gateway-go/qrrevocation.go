@@ -0,0 +1,282 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"assetTransfer/qrverify"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+// VerifyQROfflineRequest carries a scanned payload and, optionally, the signed revocation list a
+// device holds. Without one the gateway's current list is used.
+type VerifyQROfflineRequest struct {
+	Payload        string `json:"payload" binding:"required"`
+	RevocationList string `json:"revocationList"`
+}
+
+// revocationPublisher periodically signs the list of revoked DIDs for police devices to carry into
+// areas without a network. The version is the Unix time the entries last changed, so it only grows
+// and QR codes can embed the one current at issue. Devices holding a recent version download only
+// the changes since.
+type revocationPublisher struct {
+	interval time.Duration
+	validity time.Duration
+
+	mu      sync.RWMutex
+	list    *qrverify.RevocationList
+	token   string
+	digest  string
+	history versionHistory
+}
+
+var qrRevocations *revocationPublisher
+
+// initQRRevocations configures how often the revocation list is re-signed and how long devices may
+// rely on one without a newer copy
+func initQRRevocations() {
+	p := &revocationPublisher{
+		interval: getEnvDuration("DID_REVOCATION_LIST_INTERVAL", 15*time.Minute),
+		validity: getEnvDuration("DID_REVOCATION_LIST_VALIDITY", 72*time.Hour),
+		history:  versionHistory{size: getEnvInt("DID_REVOCATION_LIST_HISTORY", defaultDeltaHistory)},
+	}
+	if p.interval <= 0 || p.validity <= p.interval {
+		panic(fmt.Errorf("DID_REVOCATION_LIST_VALIDITY must exceed a positive DID_REVOCATION_LIST_INTERVAL"))
+	}
+	if p.history.size < 0 {
+		panic(fmt.Errorf("DID_REVOCATION_LIST_HISTORY must not be negative"))
+	}
+	qrRevocations = p
+	log.Printf("📵 Publishing the signed DID revocation list every %s, valid for %s", p.interval, p.validity)
+}
+
+// version is the version of the current list, or 0 before the first is published
+func (p *revocationPublisher) version() int64 {
+	if p == nil {
+		return 0
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.list == nil {
+		return 0
+	}
+	return p.list.Version
+}
+
+// current returns the latest signed list, or nil before the first is published
+func (p *revocationPublisher) current() (*qrverify.RevocationList, string) {
+	if p == nil {
+		return nil, ""
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.list, p.token
+}
+
+// publish re-signs the list from the ledger, keeping the version unless the entries changed
+func (p *revocationPublisher) publish(ctx context.Context, now time.Time) error {
+	revoked, err := revokedDIDs(ctx)
+	if err != nil {
+		return err
+	}
+	entries := make([]string, 0, len(revoked))
+	for _, id := range revoked {
+		entries = append(entries, qrverify.RevocationEntry(id))
+	}
+	slices.Sort(entries)
+	entries = slices.Compact(entries)
+	return p.sign(entries, now)
+}
+
+func (p *revocationPublisher) sign(entries []string, now time.Time) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var previous []string
+	version := int64(0)
+	if p.list != nil {
+		previous, version = p.list.Revoked, p.list.Version
+	}
+	changed := changedEntries(previous, entries, func(entry string) string { return entry })
+	version = nextVersion(version, len(changed) > 0, now)
+	list := &qrverify.RevocationList{
+		Version:   version,
+		IssuedAt:  now.UTC().Format(time.RFC3339),
+		ExpiresAt: now.Add(p.validity).UTC().Format(time.RFC3339),
+		Revoked:   entries,
+	}
+	token, err := didQRSigner.signJWT(qrverify.RevocationListType, didQRSigner.keyID, list)
+	if err != nil {
+		return err
+	}
+	if p.list == nil || version != p.list.Version {
+		p.history.record(version, changed)
+		log.Printf("📵 DID revocation list version %d lists %d DIDs", version, len(entries))
+	}
+	p.list, p.token, p.digest = list, token, qrverify.RevocationDigest(entries)
+	return nil
+}
+
+// delta signs the changes from an earlier version to the current list, or returns false when that
+// version is not remembered. A delta from the current version only renews the expiry.
+func (p *revocationPublisher) delta(from int64) (string, bool, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	changed, ok := p.history.since(from)
+	if !ok || p.list == nil {
+		return "", false, nil
+	}
+	delta := qrverify.RevocationDelta{
+		From:      from,
+		Version:   p.list.Version,
+		IssuedAt:  p.list.IssuedAt,
+		ExpiresAt: p.list.ExpiresAt,
+		Digest:    p.digest,
+	}
+	for _, entry := range changed {
+		if _, found := slices.BinarySearch(p.list.Revoked, entry); found {
+			delta.Added = append(delta.Added, entry)
+		} else {
+			delta.Removed = append(delta.Removed, entry)
+		}
+	}
+	token, err := didQRSigner.signJWT(qrverify.RevocationDeltaType, didQRSigner.keyID, delta)
+	return token, true, err
+}
+
+// revokedDIDs reads every DID deleted on the ledger that has not been created again. Like DID
+// verification, it looks past the caller's tenancy, as any organization may revoke a DID.
+func revokedDIDs(ctx context.Context) ([]string, error) {
+	deleted := map[string]bool{}
+	bookmark := ""
+	for {
+		result, err := evaluateTransaction(ctx, "QueryAudits", "", "DELETE_DID", "", "", strconv.Itoa(maxPageSize), bookmark)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Audits   []AuditDocument `json:"audits"`
+			Bookmark string          `json:"bookmark"`
+		}
+		if err := json.Unmarshal(result, &page); err != nil {
+			return nil, &malformedDocumentError{kind: "audit list", err: err}
+		}
+		for _, audit := range page.Audits {
+			deleted[audit.TargetID] = true
+		}
+		if len(page.Audits) < maxPageSize || page.Bookmark == "" {
+			break
+		}
+		bookmark = page.Bookmark
+	}
+
+	var revoked []string
+	for id := range deleted {
+		_, err := evaluateTransaction(ctx, "ReadDID", id)
+		if err == nil {
+			continue
+		}
+		if translateFabricError(err).Code != errCodeNotFound {
+			return nil, err
+		}
+		revoked = append(revoked, id)
+	}
+	return revoked, nil
+}
+
+// revocationsFromEvent republishes the list as soon as a DID is deleted or issued, which may
+// reinstate a deleted one, and the DID bundle when a DID changes at all
+func revocationsFromEvent(ctx context.Context, event *client.ChaincodeEvent) {
+	if !isDefaultTarget(ctx) {
+		return
+	}
+	switch event.EventName {
+	case "DeleteDID", "CreateDID", "BatchIssueDID":
+		scheduler.trigger(jobRevocationList)
+		scheduler.trigger(jobDIDBundle)
+	case "UpdateDID":
+		scheduler.trigger(jobDIDBundle)
+	}
+}
+
+// VerifyDIDQROffline checks a scanned payload the way a police device without a network does:
+// against the gateway's public key and a signed revocation list, never the ledger
+func (ledgerService) VerifyDIDQROffline(req VerifyQROfflineRequest) (qrverify.Verdict, error) {
+	var v fieldValidator
+	if len(req.Payload) > maxQRPayloadBytes {
+		v.add("payload", "must be at most %d bytes", maxQRPayloadBytes)
+	}
+	list, _ := qrRevocations.current()
+	if req.RevocationList != "" {
+		var err error
+		if list, err = qrverify.ParseRevocationList(req.RevocationList, didQRSigner.keys()); err != nil {
+			v.add("revocationList", "is not a revocation list signed by this gateway: %s", err)
+		}
+	}
+	if len(v.errors) > 0 {
+		return qrverify.Verdict{}, v.errors
+	}
+	return qrverify.Verify(req.Payload, didQRSigner.keys(), list, time.Now()), nil
+}
+
+// getDIDRevocationList serves the signed revocation list to devices, without an API key, or a
+// delta from the version the device holds. Entries are hashes, so the list does not disclose which
+// DIDs exist.
+func getDIDRevocationList(c *gin.Context) {
+	var req OfflineListRequest
+	if !bindQuery(c, &req) {
+		return
+	}
+	list, token := qrRevocations.current()
+	if list == nil {
+		respondError(c, http.StatusServiceUnavailable, errCodeUnavailable, "The revocation list has not been published yet")
+		return
+	}
+	if req.Since > 0 {
+		delta, ok, err := qrRevocations.delta(req.Since)
+		if err != nil {
+			respondServiceError(c, "Failed to sign the revocation list delta", err)
+			return
+		}
+		if ok {
+			c.Header("X-Revocation-List-From", strconv.FormatInt(req.Since, 10))
+			token = delta
+		}
+	}
+	c.Header("Cache-Control", "max-age=60")
+	c.Header("X-Revocation-List-Version", strconv.FormatInt(list.Version, 10))
+	c.Data(http.StatusOK, "application/jwt", []byte(token))
+}
+
+// getDIDQRKeys publishes the QR signing key as a JWK Set for devices to pin
+func getDIDQRKeys(c *gin.Context) {
+	c.Header("Cache-Control", "max-age=300")
+	c.JSON(http.StatusOK, gin.H{"keys": []gin.H{didQRSigner.publicJWK(didQRSigner.keyID)}})
+}
+
+func verifyDIDQROffline(c *gin.Context) {
+	var req VerifyQROfflineRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+	verdict, err := ledger.VerifyDIDQROffline(req)
+	if err != nil {
+		respondServiceError(c, "Failed to verify DID QR code", err)
+		return
+	}
+	respondData(c, http.StatusOK, verdict)
+}
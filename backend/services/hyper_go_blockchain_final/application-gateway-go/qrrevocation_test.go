@@ -0,0 +1,80 @@
+package gateway
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"assetTransfer/qrverify"
+)
+
+func TestRevocationListPublishing(t *testing.T) {
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	signer := didQRSigner
+	didQRSigner = newQRSigner(key)
+	defer func() { didQRSigner = signer }()
+	p := &revocationPublisher{interval: time.Minute, validity: time.Hour}
+
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	entry := qrverify.RevocationEntry("did:sih:tourist001")
+	if err := p.sign([]string{entry}, now); err != nil {
+		t.Fatal(err)
+	}
+	first := p.version()
+	if err := p.sign([]string{entry}, now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if p.version() != first {
+		t.Errorf("expected the version kept while the entries are unchanged, got %d after %d", p.version(), first)
+	}
+	// A clock running behind must not reuse a version
+	if err := p.sign([]string{}, now.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if p.version() <= first {
+		t.Errorf("expected a new version above %d, got %d", first, p.version())
+	}
+
+	_, token := p.current()
+	list, err := qrverify.ParseRevocationList(token, didQRSigner.keys())
+	if err != nil || list.Version != p.version() || len(list.Revoked) != 0 {
+		t.Fatalf("expected the signed list to parse, got %+v, %v", list, err)
+	}
+}
+
+func TestVerifyDIDQROffline(t *testing.T) {
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	signer, publisher := didQRSigner, qrRevocations
+	didQRSigner = newQRSigner(key)
+	qrRevocations = &revocationPublisher{interval: time.Minute, validity: time.Hour}
+	defer func() { didQRSigner, qrRevocations = signer, publisher }()
+
+	now := time.Now()
+	if err := qrRevocations.sign([]string{}, now); err != nil {
+		t.Fatal(err)
+	}
+	payload, _ := didQRSigner.sign(DIDQRClaims{
+		DigitalID: "did:sih:tourist001", ExpiresAt: now.Add(24 * time.Hour).UTC().Format(time.RFC3339),
+		RevocationListVersion: qrRevocations.version(),
+	})
+	_, held := qrRevocations.current()
+
+	verdict, err := ledger.VerifyDIDQROffline(VerifyQROfflineRequest{Payload: payload})
+	if err != nil || !verdict.Valid {
+		t.Fatalf("expected the QR valid, got %+v, %v", verdict, err)
+	}
+
+	if err := qrRevocations.sign([]string{qrverify.RevocationEntry("did:sih:tourist001")}, now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if verdict, _ := ledger.VerifyDIDQROffline(VerifyQROfflineRequest{Payload: payload}); verdict.Reason != "revoked" {
+		t.Errorf("expected the QR revoked by the newer list, got %+v", verdict)
+	}
+	if verdict, _ := ledger.VerifyDIDQROffline(VerifyQROfflineRequest{Payload: payload, RevocationList: held}); !verdict.Valid {
+		t.Errorf("expected the QR valid against the list the device holds, got %+v", verdict)
+	}
+	if _, err := ledger.VerifyDIDQROffline(VerifyQROfflineRequest{Payload: payload, RevocationList: "forged"}); err == nil {
+		t.Error("expected a list not signed by the gateway rejected")
+	}
+}
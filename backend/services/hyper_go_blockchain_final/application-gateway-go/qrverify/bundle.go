@@ -0,0 +1,225 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package qrverify
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// RevocationDeltaType is the typ header of a signed revocation list delta
+	RevocationDeltaType = "sih-revocation-delta+jwt"
+	// BundleType is the typ header of a signed DID bundle
+	BundleType = "sih-did-bundle+jwt"
+	// BundleDeltaType is the typ header of a signed DID bundle delta
+	BundleDeltaType = "sih-did-bundle-delta+jwt"
+)
+
+// ErrDeltaMismatch means a delta does not apply to the list or bundle a device holds, which must
+// then download it whole
+var ErrDeltaMismatch = errors.New("delta_mismatch")
+
+// RevocationDelta turns the revocation list of version From into the one of Version. Digest is
+// RevocationDigest of the resulting entries, so a device can tell it applied the delta to the
+// list it was computed from.
+type RevocationDelta struct {
+	From      int64    `json:"from"`
+	Version   int64    `json:"ver"`
+	IssuedAt  string   `json:"iat"`
+	ExpiresAt string   `json:"exp"`
+	Added     []string `json:"added,omitempty"`
+	Removed   []string `json:"removed,omitempty"`
+	Digest    string   `json:"digest"`
+}
+
+// BundleEntry is a DID that was valid when its bundle was issued, named by RevocationEntry
+type BundleEntry struct {
+	Entry     string `json:"id"`
+	Issuer    string `json:"iss"`
+	ExpiresAt string `json:"exp"`
+}
+
+// Bundle lists the unexpired DIDs on the ledger, sorted by entry, for a device to check a DID it
+// is shown without a QR code. A DID missing from the bundle was never issued, or was deleted or
+// expired before it. Version only grows, and changes when the entries do; a bundle is not to be
+// trusted after ExpiresAt.
+type Bundle struct {
+	Version   int64         `json:"ver"`
+	IssuedAt  string        `json:"iat"`
+	ExpiresAt string        `json:"exp"`
+	DIDs      []BundleEntry `json:"dids"`
+}
+
+// BundleDelta turns the bundle of version From into the one of Version. Upserted entries are new
+// or changed; Digest is BundleDigest of the resulting entries.
+type BundleDelta struct {
+	From      int64         `json:"from"`
+	Version   int64         `json:"ver"`
+	IssuedAt  string        `json:"iat"`
+	ExpiresAt string        `json:"exp"`
+	Upserted  []BundleEntry `json:"upserted,omitempty"`
+	Removed   []string      `json:"removed,omitempty"`
+	Digest    string        `json:"digest"`
+}
+
+// DIDVerdict is the offline verdict on a DID looked up in a bundle. When Valid is false, Reason is
+// one of no_bundle, bundle_expired, unknown_did or expired.
+type DIDVerdict struct {
+	Valid         bool   `json:"valid"`
+	Known         bool   `json:"known"`
+	Expired       bool   `json:"expired"`
+	Reason        string `json:"reason,omitempty"`
+	Issuer        string `json:"issuer,omitempty"`
+	ExpiresAt     string `json:"expires_at,omitempty"`
+	BundleVersion int64  `json:"bundle_version,omitempty"`
+}
+
+// RevocationDigest is the hex SHA-256 of the sorted entries, each followed by a newline
+func RevocationDigest(entries []string) string {
+	h := sha256.New()
+	for _, entry := range entries {
+		h.Write([]byte(entry + "\n"))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// BundleDigest is the hex SHA-256 of the sorted entries, each written as its ID, issuer and expiry
+// separated by tabs and followed by a newline
+func BundleDigest(entries []BundleEntry) string {
+	h := sha256.New()
+	for _, entry := range entries {
+		h.Write([]byte(entry.Entry + "\t" + entry.Issuer + "\t" + entry.ExpiresAt + "\n"))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// ParseRevocationDelta checks a revocation list delta's signature and returns it
+func ParseRevocationDelta(token string, keys Keys) (*RevocationDelta, error) {
+	var delta RevocationDelta
+	if err := parseToken(token, RevocationDeltaType, keys, &delta); err != nil {
+		return nil, err
+	}
+	return &delta, nil
+}
+
+// ParseBundle checks a DID bundle's signature and returns it. Expiry is left to Check.
+func ParseBundle(token string, keys Keys) (*Bundle, error) {
+	var bundle Bundle
+	if err := parseToken(token, BundleType, keys, &bundle); err != nil {
+		return nil, err
+	}
+	if !slices.IsSortedFunc(bundle.DIDs, compareEntries) {
+		return nil, ErrMalformed
+	}
+	return &bundle, nil
+}
+
+// ParseBundleDelta checks a DID bundle delta's signature and returns it
+func ParseBundleDelta(token string, keys Keys) (*BundleDelta, error) {
+	var delta BundleDelta
+	if err := parseToken(token, BundleDeltaType, keys, &delta); err != nil {
+		return nil, err
+	}
+	return &delta, nil
+}
+
+func compareEntries(a, b BundleEntry) int {
+	return strings.Compare(a.Entry, b.Entry)
+}
+
+// Apply returns the list the delta leads to, leaving l as it is. It fails with ErrDeltaMismatch
+// unless the delta starts from l's version and ends at the entries it was signed for.
+func (l *RevocationList) Apply(delta *RevocationDelta) (*RevocationList, error) {
+	if delta.From != l.Version {
+		return nil, ErrDeltaMismatch
+	}
+	revoked := make(map[string]bool, len(l.Revoked)+len(delta.Added))
+	for _, entry := range l.Revoked {
+		revoked[entry] = true
+	}
+	for _, entry := range delta.Removed {
+		delete(revoked, entry)
+	}
+	for _, entry := range delta.Added {
+		revoked[entry] = true
+	}
+	entries := make([]string, 0, len(revoked))
+	for entry := range revoked {
+		entries = append(entries, entry)
+	}
+	slices.Sort(entries)
+	if RevocationDigest(entries) != delta.Digest {
+		return nil, ErrDeltaMismatch
+	}
+	return &RevocationList{Version: delta.Version, IssuedAt: delta.IssuedAt, ExpiresAt: delta.ExpiresAt, Revoked: entries}, nil
+}
+
+// Apply returns the bundle the delta leads to, leaving b as it is. It fails with ErrDeltaMismatch
+// unless the delta starts from b's version and ends at the entries it was signed for.
+func (b *Bundle) Apply(delta *BundleDelta) (*Bundle, error) {
+	if delta.From != b.Version {
+		return nil, ErrDeltaMismatch
+	}
+	dids := make(map[string]BundleEntry, len(b.DIDs)+len(delta.Upserted))
+	for _, entry := range b.DIDs {
+		dids[entry.Entry] = entry
+	}
+	for _, entry := range delta.Removed {
+		delete(dids, entry)
+	}
+	for _, entry := range delta.Upserted {
+		dids[entry.Entry] = entry
+	}
+	entries := make([]BundleEntry, 0, len(dids))
+	for _, entry := range dids {
+		entries = append(entries, entry)
+	}
+	slices.SortFunc(entries, compareEntries)
+	if BundleDigest(entries) != delta.Digest {
+		return nil, ErrDeltaMismatch
+	}
+	return &Bundle{Version: delta.Version, IssuedAt: delta.IssuedAt, ExpiresAt: delta.ExpiresAt, DIDs: entries}, nil
+}
+
+// Lookup returns the bundle's entry for a DID
+func (b *Bundle) Lookup(digitalID string) (BundleEntry, bool) {
+	i, found := slices.BinarySearchFunc(b.DIDs, BundleEntry{Entry: RevocationEntry(digitalID)}, compareEntries)
+	if !found {
+		return BundleEntry{}, false
+	}
+	return b.DIDs[i], true
+}
+
+// Check looks a DID up in a bundle already checked by ParseBundle, or built by Apply
+func Check(digitalID string, bundle *Bundle, now time.Time) DIDVerdict {
+	if bundle == nil {
+		return DIDVerdict{Reason: "no_bundle"}
+	}
+	verdict := DIDVerdict{BundleVersion: bundle.Version}
+	if expiresAt, err := time.Parse(time.RFC3339, bundle.ExpiresAt); err != nil || !expiresAt.After(now) {
+		verdict.Reason = "bundle_expired"
+		return verdict
+	}
+	entry, found := bundle.Lookup(digitalID)
+	if !found {
+		verdict.Reason = "unknown_did"
+		return verdict
+	}
+	verdict.Known, verdict.Issuer, verdict.ExpiresAt = true, entry.Issuer, entry.ExpiresAt
+	if expiresAt, err := time.Parse(time.RFC3339, entry.ExpiresAt); err != nil || !expiresAt.After(now) {
+		verdict.Expired = true
+		verdict.Reason = "expired"
+		return verdict
+	}
+	verdict.Valid = true
+	return verdict
+}
@@ -0,0 +1,208 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package qrverify checks DID QR codes without a network connection. A police device pins the
+// gateway's public keys, downloads the signed revocation list whenever it has signal, and then
+// verifies scanned codes against both. The signed DID bundle lets it check a DID it is shown
+// without a code. Both are kept current with small deltas. It depends only on the standard
+// library so it can be built for handheld devices.
+package qrverify
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// PayloadPrefix starts the text of every DID QR code
+	PayloadPrefix = "SIH1."
+	// RevocationListType is the typ header of a signed revocation list
+	RevocationListType = "sih-revocation-list+jwt"
+)
+
+// Verification failures; the messages double as verdict reasons
+var (
+	ErrMalformed    = errors.New("malformed")
+	ErrUnknownKey   = errors.New("unknown_key")
+	ErrBadSignature = errors.New("invalid_signature")
+)
+
+// Claims are the fields signed into a DID QR code. RevocationListVersion is the version of the
+// revocation list published when the code was issued; the DID verified on the ledger then, so a
+// list that old or older cannot revoke it.
+type Claims struct {
+	DigitalID             string `json:"did"`
+	Issuer                string `json:"iss"`
+	ExpiresAt             string `json:"exp"`
+	IssuedAt              string `json:"iat"`
+	KeyID                 string `json:"kid"`
+	RevocationListVersion int64  `json:"rlv,omitempty"`
+}
+
+// RevocationList names the revoked DIDs by RevocationEntry, sorted. Version only grows, and
+// changes when the entries do; a list is not to be trusted after ExpiresAt.
+type RevocationList struct {
+	Version   int64    `json:"ver"`
+	IssuedAt  string   `json:"iat"`
+	ExpiresAt string   `json:"exp"`
+	Revoked   []string `json:"revoked"`
+}
+
+// Verdict is the offline verdict on a scanned DID QR code. Valid requires a good signature, an
+// unexpired payload, an unexpired revocation list and a DID the list does not revoke.
+type Verdict struct {
+	Valid                 bool    `json:"valid"`
+	SignatureValid        bool    `json:"signature_valid"`
+	Expired               bool    `json:"expired"`
+	Revoked               bool    `json:"revoked"`
+	Reason                string  `json:"reason,omitempty"`
+	Claims                *Claims `json:"claims,omitempty"`
+	RevocationListVersion int64   `json:"revocation_list_version,omitempty"`
+}
+
+// Keys are the trusted public keys by key ID
+type Keys map[string]ed25519.PublicKey
+
+// KeyID derives the ID the gateway gives a public key: the hex of its SHA-256's first 8 bytes
+func KeyID(key ed25519.PublicKey) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+// RevocationEntry is how a revocation list names a DID: the hex of the first 16 bytes of its
+// SHA-256, so the list does not disclose which DIDs exist
+func RevocationEntry(digitalID string) string {
+	sum := sha256.Sum256([]byte(digitalID))
+	return hex.EncodeToString(sum[:16])
+}
+
+// ParsePayload checks a payload's signature and returns its claims. The claims are returned with
+// an unknown key or a bad signature too, for the verdict to describe.
+func ParsePayload(payload string, keys Keys) (*Claims, error) {
+	if !strings.HasPrefix(payload, PayloadPrefix) {
+		return nil, ErrMalformed
+	}
+	encodedClaims, encodedSignature, ok := strings.Cut(strings.TrimPrefix(payload, PayloadPrefix), ".")
+	if !ok {
+		return nil, ErrMalformed
+	}
+
+	body, err := base64.RawURLEncoding.DecodeString(encodedClaims)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	var claims Claims
+	if err := json.Unmarshal(body, &claims); err != nil {
+		return nil, ErrMalformed
+	}
+
+	key, ok := keys[claims.KeyID]
+	if !ok {
+		return &claims, ErrUnknownKey
+	}
+	if !ed25519.Verify(key, []byte(PayloadPrefix+encodedClaims), signature) {
+		return &claims, ErrBadSignature
+	}
+	return &claims, nil
+}
+
+// ParseRevocationList checks a revocation list's signature and returns it. Expiry is left to
+// Verify, so a device can still show how old the list it holds is.
+func ParseRevocationList(token string, keys Keys) (*RevocationList, error) {
+	var list RevocationList
+	if err := parseToken(token, RevocationListType, keys, &list); err != nil {
+		return nil, err
+	}
+	if !slices.IsSorted(list.Revoked) {
+		return nil, ErrMalformed
+	}
+	return &list, nil
+}
+
+// parseToken checks the signature of a compact JWS of type typ and decodes its claims into v
+func parseToken(token, typ string, keys Keys, v interface{}) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ErrMalformed
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Typ string `json:"typ"`
+		Kid string `json:"kid"`
+	}
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(headerJSON, &header) != nil || header.Alg != "EdDSA" || header.Typ != typ {
+		return ErrMalformed
+	}
+	body, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || json.Unmarshal(body, v) != nil {
+		return ErrMalformed
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return ErrMalformed
+	}
+
+	key, ok := keys[header.Kid]
+	if !ok {
+		return ErrUnknownKey
+	}
+	if !ed25519.Verify(key, []byte(parts[0]+"."+parts[1]), signature) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+// Contains reports whether the list revokes a DID
+func (l *RevocationList) Contains(digitalID string) bool {
+	_, found := slices.BinarySearch(l.Revoked, RevocationEntry(digitalID))
+	return found
+}
+
+// Verify checks a scanned payload against the trusted keys and a revocation list already checked
+// by ParseRevocationList. When valid is false, reason is one of malformed, unknown_key,
+// invalid_signature, expired, no_revocation_list, revocation_list_expired or revoked.
+func Verify(payload string, keys Keys, list *RevocationList, now time.Time) Verdict {
+	claims, err := ParsePayload(payload, keys)
+	if err != nil {
+		return Verdict{Reason: err.Error(), Claims: claims}
+	}
+
+	verdict := Verdict{SignatureValid: true, Claims: claims}
+	if expiresAt, err := time.Parse(time.RFC3339, claims.ExpiresAt); err != nil || !expiresAt.After(now) {
+		verdict.Expired = true
+		verdict.Reason = "expired"
+		return verdict
+	}
+	if list == nil {
+		verdict.Reason = "no_revocation_list"
+		return verdict
+	}
+	verdict.RevocationListVersion = list.Version
+	if expiresAt, err := time.Parse(time.RFC3339, list.ExpiresAt); err != nil || !expiresAt.After(now) {
+		verdict.Reason = "revocation_list_expired"
+		return verdict
+	}
+	// A list no newer than the one current at issue predates the DID being valid again
+	if list.Version > claims.RevocationListVersion && list.Contains(claims.DigitalID) {
+		verdict.Revoked = true
+		verdict.Reason = "revoked"
+		return verdict
+	}
+	verdict.Valid = true
+	return verdict
+}
@@ -0,0 +1,160 @@
+package qrverify
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"slices"
+	"testing"
+	"time"
+)
+
+func testKey(t *testing.T) (ed25519.PrivateKey, Keys) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return private, Keys{KeyID(public): public}
+}
+
+func signPayload(key ed25519.PrivateKey, claims Claims) string {
+	claims.KeyID = KeyID(key.Public().(ed25519.PublicKey))
+	body, _ := json.Marshal(claims)
+	signed := PayloadPrefix + base64.RawURLEncoding.EncodeToString(body)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(ed25519.Sign(key, []byte(signed)))
+}
+
+func signList(key ed25519.PrivateKey, typ string, list interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "EdDSA", "typ": typ, "kid": KeyID(key.Public().(ed25519.PublicKey))})
+	body, _ := json.Marshal(list)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(body)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(ed25519.Sign(key, []byte(signed)))
+}
+
+func TestVerify(t *testing.T) {
+	key, keys := testKey(t)
+	other, _ := testKey(t)
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	revoked := []string{RevocationEntry("did:sih:revoked"), RevocationEntry("did:sih:reinstated")}
+	slices.Sort(revoked)
+	list, err := ParseRevocationList(signList(key, RevocationListType, RevocationList{
+		Version: 200, IssuedAt: "2026-03-01T00:00:00Z", ExpiresAt: "2026-03-04T00:00:00Z", Revoked: revoked,
+	}), keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired := *list
+	expired.ExpiresAt = "2026-03-01T11:00:00Z"
+
+	claims := func(did string, rlv int64) Claims {
+		return Claims{DigitalID: did, Issuer: "tourism_authority", ExpiresAt: "2026-12-31T00:00:00Z", RevocationListVersion: rlv}
+	}
+	tests := []struct {
+		name    string
+		payload string
+		list    *RevocationList
+		reason  string
+	}{
+		{"valid", signPayload(key, claims("did:sih:tourist001", 150)), list, ""},
+		{"revoked after issue", signPayload(key, claims("did:sih:revoked", 150)), list, "revoked"},
+		{"reinstated before issue", signPayload(key, claims("did:sih:reinstated", 200)), list, ""},
+		{"expired payload", signPayload(key, Claims{DigitalID: "did:sih:tourist001", ExpiresAt: "2026-02-01T00:00:00Z"}), list, "expired"},
+		{"expired list", signPayload(key, claims("did:sih:tourist001", 150)), &expired, "revocation_list_expired"},
+		{"no list", signPayload(key, claims("did:sih:tourist001", 150)), nil, "no_revocation_list"},
+		{"unknown key", signPayload(other, claims("did:sih:tourist001", 150)), list, "unknown_key"},
+		{"garbage", "not a payload", list, "malformed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verdict := Verify(tt.payload, keys, tt.list, now)
+			if verdict.Reason != tt.reason || verdict.Valid != (tt.reason == "") {
+				t.Errorf("expected reason %q, got %+v", tt.reason, verdict)
+			}
+		})
+	}
+
+	payload := signPayload(key, claims("did:sih:tourist001", 150))
+	tampered := payload[:len(payload)-4] + "AAAA"
+	if verdict := Verify(tampered, keys, list, now); verdict.Reason != "invalid_signature" || verdict.Claims == nil {
+		t.Errorf("expected a tampered payload rejected with its claims, got %+v", verdict)
+	}
+}
+
+func TestParseRevocationList(t *testing.T) {
+	key, keys := testKey(t)
+	other, _ := testKey(t)
+	list := RevocationList{Version: 1, ExpiresAt: "2030-01-01T00:00:00Z", Revoked: []string{"b", "a"}}
+	if _, err := ParseRevocationList(signList(key, RevocationListType, list), keys); err != ErrMalformed {
+		t.Errorf("unsorted list err = %v, want ErrMalformed", err)
+	}
+	list.Revoked = []string{"a", "b"}
+	if _, err := ParseRevocationList(signList(key, "erasure-certificate+jwt", list), keys); err != ErrMalformed {
+		t.Errorf("wrong typ err = %v, want ErrMalformed", err)
+	}
+	if _, err := ParseRevocationList(signList(other, RevocationListType, list), keys); err != ErrUnknownKey {
+		t.Errorf("foreign key err = %v, want ErrUnknownKey", err)
+	}
+	if parsed, err := ParseRevocationList(signList(key, RevocationListType, list), keys); err != nil || parsed.Version != 1 {
+		t.Errorf("expected the list parsed, got %+v, %v", parsed, err)
+	}
+}
+
+func TestBundleDeltas(t *testing.T) {
+	key, keys := testKey(t)
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	entry := func(did, expires string) BundleEntry {
+		return BundleEntry{Entry: RevocationEntry(did), Issuer: "tourism_authority", ExpiresAt: expires}
+	}
+	dids := []BundleEntry{entry("did:sih:tourist001", "2026-12-31T00:00:00Z"), entry("did:sih:tourist002", "2026-12-31T00:00:00Z"), entry("did:sih:lapsed", "2026-03-01T06:00:00Z")}
+	slices.SortFunc(dids, compareEntries)
+	bundle, err := ParseBundle(signList(key, BundleType, Bundle{Version: 100, ExpiresAt: "2026-03-04T00:00:00Z", DIDs: dids}), keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for did, reason := range map[string]string{"did:sih:tourist001": "", "did:sih:tourist003": "unknown_did", "did:sih:lapsed": "expired"} {
+		if verdict := Check(did, bundle, now); verdict.Reason != reason || verdict.Valid != (reason == "") {
+			t.Errorf("%s: expected reason %q, got %+v", did, reason, verdict)
+		}
+	}
+
+	// tourist002 is deleted and tourist003 issued
+	want := []BundleEntry{dids[0], dids[1], dids[2]}
+	want = slices.DeleteFunc(want, func(e BundleEntry) bool { return e.Entry == RevocationEntry("did:sih:tourist002") })
+	want = append(want, entry("did:sih:tourist003", "2027-01-31T00:00:00Z"))
+	slices.SortFunc(want, compareEntries)
+	delta, err := ParseBundleDelta(signList(key, BundleDeltaType, BundleDelta{
+		From: 100, Version: 120, ExpiresAt: "2026-03-05T00:00:00Z",
+		Upserted: []BundleEntry{entry("did:sih:tourist003", "2027-01-31T00:00:00Z")},
+		Removed:  []string{RevocationEntry("did:sih:tourist002")},
+		Digest:   BundleDigest(want),
+	}), keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	updated, err := bundle.Apply(delta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Version != 120 || !Check("did:sih:tourist003", updated, now).Valid || Check("did:sih:tourist002", updated, now).Known || len(bundle.DIDs) != 3 {
+		t.Errorf("expected the delta applied to a copy, got %+v", updated)
+	}
+	if _, err := updated.Apply(delta); err != ErrDeltaMismatch {
+		t.Errorf("delta from another version err = %v, want ErrDeltaMismatch", err)
+	}
+	delta.Digest = BundleDigest(dids)
+	if _, err := bundle.Apply(delta); err != ErrDeltaMismatch {
+		t.Errorf("delta to other entries err = %v, want ErrDeltaMismatch", err)
+	}
+
+	list := &RevocationList{Version: 7, Revoked: []string{"a", "c"}}
+	listDelta, err := ParseRevocationDelta(signList(key, RevocationDeltaType, RevocationDelta{
+		From: 7, Version: 9, ExpiresAt: "2026-03-05T00:00:00Z", Added: []string{"b"}, Removed: []string{"c"}, Digest: RevocationDigest([]string{"a", "b"}),
+	}), keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if applied, err := list.Apply(listDelta); err != nil || applied.Version != 9 || !slices.Equal(applied.Revoked, []string{"a", "b"}) {
+		t.Errorf("expected the revocation delta applied, got %+v, %v", applied, err)
+	}
+}
@@ -0,0 +1,181 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	errCodeRateLimited = "RATE_LIMITED"
+	apiKeyHeader       = "X-API-Key"
+	rateLimitIdleTTL   = 10 * time.Minute
+)
+
+// tokenBucket refills at rate tokens per second up to burst
+type tokenBucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+// rateLimiter holds one bucket per client key for a single budget
+type rateLimiter struct {
+	rate  float64
+	burst float64
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+func newRateLimiter(perMinute, burst int) *rateLimiter {
+	return &rateLimiter{
+		rate:    float64(perMinute) / 60,
+		burst:   float64(burst),
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// allow takes a token for key, returning how long to wait when the bucket is empty
+func (l *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: l.burst, lastSeen: now}
+		l.buckets[key] = bucket
+	}
+
+	elapsed := now.Sub(bucket.lastSeen).Seconds()
+	bucket.tokens = math.Min(l.burst, bucket.tokens+elapsed*l.rate)
+	bucket.lastSeen = now
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - bucket.tokens) / l.rate * float64(time.Second))
+	return false, wait
+}
+
+// evictIdle forgets clients whose buckets have been full for a while
+func (l *rateLimiter) evictIdle(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for key, bucket := range l.buckets {
+		if now.Sub(bucket.lastSeen) > rateLimitIdleTTL {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// rateLimitMiddleware applies separate read and write token buckets per client. Reads are GET and
+// HEAD; everything else, including SOS and evidence submissions, draws from the write budget.
+func rateLimitMiddleware(ctx context.Context) gin.HandlerFunc {
+	if !getEnvBool("RATE_LIMIT_ENABLED", true) {
+		log.Println("🚦 Rate limiting disabled")
+		return func(c *gin.Context) { c.Next() }
+	}
+
+	reads := newRateLimiter(getEnvInt("RATE_LIMIT_READ_PER_MINUTE", 600), getEnvInt("RATE_LIMIT_READ_BURST", 100))
+	writes := newRateLimiter(getEnvInt("RATE_LIMIT_WRITE_PER_MINUTE", 60), getEnvInt("RATE_LIMIT_WRITE_BURST", 20))
+	log.Printf("🚦 Rate limiting reads %.0f/min (burst %.0f), writes %.0f/min (burst %.0f)",
+		reads.rate*60, reads.burst, writes.rate*60, writes.burst)
+
+	go evictIdleBuckets(ctx, reads, writes)
+
+	return func(c *gin.Context) {
+		limiter := writes
+		// GraphQL only exposes queries, so POSTs to it spend the read budget
+		if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead || c.FullPath() == "/graphql" {
+			limiter = reads
+		}
+
+		if ok, wait := limiter.allow(rateLimitKey(c), time.Now()); !ok {
+			rejectRateLimited(c, wait)
+			return
+		}
+		c.Next()
+	}
+}
+
+// evictIdleBuckets forgets idle clients of the limiters every minute until ctx is done
+func evictIdleBuckets(ctx context.Context, limiters ...*rateLimiter) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, l := range limiters {
+				l.evictIdle(now)
+			}
+		}
+	}
+}
+
+// rejectRateLimited answers 429 with the time until the client's bucket has a token again
+func rejectRateLimited(c *gin.Context, wait time.Duration) {
+	retryAfter := int(math.Ceil(wait.Seconds()))
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+	respondError(c, http.StatusTooManyRequests, errCodeRateLimited,
+		fmt.Sprintf("Rate limit exceeded, retry in %d seconds", retryAfter))
+	c.Abort()
+}
+
+// rateLimitKey identifies the client by the wallet identity or client certificate its credentials
+// authenticate as, otherwise by source IP. Credentials that do not authenticate, or that cannot
+// because no wallet is loaded, count against the IP, so rotating them does not earn a new bucket.
+func rateLimitKey(c *gin.Context) string {
+	if authenticateRequest(c) == nil {
+		ctx := c.Request.Context()
+		if id, ok := ctx.Value(signerContextKey{}).(*walletIdentity); ok && id != nil {
+			return "wallet:" + id.Label
+		}
+		if caller := clientCertFromContext(ctx); caller != nil {
+			return "cert:" + caller.Name
+		}
+	}
+	return "ip:" + c.ClientIP()
+}
+
+// bearerSubject extracts the sub claim from a bearer JWT without verifying it. The result only
+// labels audit entries of callers no identity was authenticated for, never authorises them.
+func bearerSubject(authorization string) string {
+	token, ok := strings.CutPrefix(authorization, "Bearer ")
+	if !ok {
+		return ""
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ""
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return ""
+	}
+	var claims struct {
+		Subject string `json:"sub"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return ""
+	}
+	return claims.Subject
+}
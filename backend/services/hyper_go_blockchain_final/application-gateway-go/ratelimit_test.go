@@ -0,0 +1,149 @@
+package gateway
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	limiter := newRateLimiter(60, 2)
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := limiter.allow("kiosk", now); !ok {
+			t.Fatalf("request %d within burst was rejected", i+1)
+		}
+	}
+	ok, wait := limiter.allow("kiosk", now)
+	if ok {
+		t.Fatal("request beyond burst was allowed")
+	}
+	if wait <= 0 || wait > time.Second {
+		t.Errorf("unexpected wait %s", wait)
+	}
+
+	if ok, _ := limiter.allow("sos-app", now); !ok {
+		t.Error("a separate client shares the exhausted bucket")
+	}
+	if ok, _ := limiter.allow("kiosk", now.Add(time.Second)); !ok {
+		t.Error("bucket did not refill after one second")
+	}
+}
+
+func TestBearerSubject(t *testing.T) {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"officer-7"}`))
+	if got := bearerSubject("Bearer header." + payload + ".sig"); got != "officer-7" {
+		t.Errorf("got %q, want officer-7", got)
+	}
+	for _, header := range []string{"", "Basic abc", "Bearer not-a-jwt"} {
+		if got := bearerSubject(header); got != "" {
+			t.Errorf("bearerSubject(%q) = %q, want empty", header, got)
+		}
+	}
+}
+
+func TestRateLimitKeyedOnAuthenticatedCaller(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("RATE_LIMIT_READ_BURST", "2")
+	previous := callerWallet
+	sum := sha256.Sum256([]byte("shift-key"))
+	callerWallet = &wallet{
+		identities: map[string]*walletIdentity{"officer-1": {Label: "officer-1"}},
+		callers:    map[string]string{hex.EncodeToString(sum[:]): "officer-1"},
+	}
+	defer func() { callerWallet = previous }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	router := gin.New()
+	router.GET("/api/v1/tourists", rateLimitMiddleware(ctx), signerMiddleware(), func(c *gin.Context) { c.Status(http.StatusOK) })
+	get := func(apiKey, authorization string) int {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/tourists", nil)
+		req.RemoteAddr = "203.0.113.9:40000"
+		if apiKey != "" {
+			req.Header.Set(apiKeyHeader, apiKey)
+		}
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Each bogus key is refused, but they all draw on the same address's bucket
+	for i := 0; i < 2; i++ {
+		if code := get(fmt.Sprintf("bogus-%d", i), ""); code != http.StatusUnauthorized {
+			t.Fatalf("bogus key %d: got %d, want 401", i, code)
+		}
+	}
+	if code := get("bogus-2", ""); code != http.StatusTooManyRequests {
+		t.Errorf("expected rotating bogus keys throttled, got %d", code)
+	}
+	// A forged token naming the officer neither escapes the address's bucket nor drains the officer's
+	forged := "Bearer header." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"officer-1"}`)) + ".sig"
+	if code := get("", forged); code != http.StatusTooManyRequests {
+		t.Errorf("expected a forged token throttled with the address, got %d", code)
+	}
+	for i := 0; i < 2; i++ {
+		if code := get("shift-key", ""); code != http.StatusOK {
+			t.Fatalf("authenticated request %d: got %d, want 200", i+1, code)
+		}
+	}
+	if code := get("shift-key", ""); code != http.StatusTooManyRequests {
+		t.Errorf("expected the officer's own bucket exhausted, got %d", code)
+	}
+}
+
+func TestRateLimitIgnoresSpoofedForwardedFor(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("RATE_LIMIT_READ_BURST", "1")
+	get := func(router *gin.Engine, forwardedFor string) int {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/tourists", nil)
+		req.RemoteAddr = "203.0.113.9:40000"
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+	newRouter := func(ctx context.Context) *gin.Engine {
+		router := gin.New()
+		setTrustedProxies(router)
+		router.GET("/api/v1/tourists", rateLimitMiddleware(ctx), func(c *gin.Context) { c.Status(http.StatusOK) })
+		return router
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Without trusted proxies, a new X-Forwarded-For on each request does not reset the bucket
+	router := newRouter(ctx)
+	if code := get(router, "198.51.100.1"); code != http.StatusOK {
+		t.Fatalf("first request: got %d, want 200", code)
+	}
+	for i := 2; i < 6; i++ {
+		if code := get(router, fmt.Sprintf("198.51.100.%d", i)); code != http.StatusTooManyRequests {
+			t.Errorf("spoofed address %d: got %d, want 429", i, code)
+		}
+	}
+
+	// Behind a trusted proxy, the client it reports is the one limited
+	t.Setenv("TRUSTED_PROXIES", "203.0.113.0/24")
+	router = newRouter(ctx)
+	for i := 1; i < 4; i++ {
+		if code := get(router, fmt.Sprintf("198.51.100.%d", i)); code != http.StatusOK {
+			t.Errorf("client %d behind the proxy: got %d, want 200", i, code)
+		}
+	}
+	if code := get(router, "198.51.100.1"); code != http.StatusTooManyRequests {
+		t.Errorf("expected the proxied client's bucket exhausted, got %d", code)
+	}
+}
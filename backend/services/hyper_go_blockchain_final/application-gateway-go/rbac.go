@@ -0,0 +1,534 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Actions a policy rule can grant. Reads through POST bodies, such as QR verification and geofence
+// evaluation, are reads; bulk exports are their own action.
+const (
+	actionRead   = "read"
+	actionWrite  = "write"
+	actionDelete = "delete"
+	actionExport = "export"
+	// Decrypting evidence is checked when an encrypted file is downloaded: "decrypt" covers
+	// incidents the caller's unit is assigned to, "decrypt_any" every incident
+	actionDecrypt    = "decrypt"
+	actionDecryptAny = "decrypt_any"
+	// Approving or rejecting an enrollment request, so requesters cannot review their own
+	actionApprove = "approve"
+)
+
+// routeActions overrides the action a REST route is checked against when its method says otherwise
+var routeActions = map[string]string{
+	"POST /api/v1/did/verify-qr":                           actionRead,
+	"POST /api/v1/did/verify-qr/offline":                   actionRead,
+	"POST /api/v1/geofence/evaluate":                       actionRead,
+	"POST /api/v1/workflows/dry-run":                       actionRead,
+	"POST /api/v1/offline/status":                          actionRead,
+	"POST /api/v1/cctv/manifests/:id/clips/:clipId/verify": actionRead,
+	"GET /api/v1/incident/export":                          actionExport,
+	"GET /api/v1/audit/export":                             actionExport,
+	"GET /api/v1/audit/compliance":                         actionExport,
+	"POST /api/v1/enrollments/requests/:id/approve":        actionApprove,
+	"POST /api/v1/enrollments/requests/:id/reject":         actionApprove,
+	"POST /api/v1/permits/applications/:id/approve":        actionApprove,
+	"POST /api/v1/permits/applications/:id/reject":         actionApprove,
+	"POST /api/v1/permits/verify":                          actionRead,
+	"POST /graphql":                                        actionRead,
+}
+
+// policyRule grants a role an action on a resource. Org, when not "*", limits the rule to callers
+// signing for that MSP.
+type policyRule struct {
+	Role     string `json:"role"`
+	Resource string `json:"resource"`
+	Action   string `json:"action"`
+	Org      string `json:"org"`
+}
+
+// roleBinding gives a subject a role. Subjects are "wallet:<label>", "cert:<name>", "org:<mspid>",
+// "*" for every caller, or another role, whose grants the role then inherits.
+type roleBinding struct {
+	Subject string `json:"subject"`
+	Role    string `json:"role"`
+}
+
+// accessPolicy is a parsed policy file in the casbin CSV layout: "p, role, resource, action[, org]"
+// and "g, subject, role" lines
+type accessPolicy struct {
+	Rules    []policyRule  `json:"rules"`
+	Bindings []roleBinding `json:"bindings"`
+	LoadedAt string        `json:"loaded_at"`
+
+	text string
+}
+
+func parseAccessPolicy(text string) (*accessPolicy, error) {
+	policy := &accessPolicy{Rules: []policyRule{}, Bindings: []roleBinding{}, LoadedAt: time.Now().UTC().Format(time.RFC3339), text: text}
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for line := 1; scanner.Scan(); line++ {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		fields := strings.Split(entry, ",")
+		for i := range fields {
+			fields[i] = strings.TrimSpace(fields[i])
+			if fields[i] == "" {
+				return nil, fmt.Errorf("line %d: empty field", line)
+			}
+		}
+		switch {
+		case fields[0] == "p" && (len(fields) == 4 || len(fields) == 5):
+			rule := policyRule{Role: fields[1], Resource: fields[2], Action: fields[3], Org: "*"}
+			if len(fields) == 5 {
+				rule.Org = fields[4]
+			}
+			policy.Rules = append(policy.Rules, rule)
+		case fields[0] == "g" && len(fields) == 3:
+			policy.Bindings = append(policy.Bindings, roleBinding{Subject: fields[1], Role: fields[2]})
+		default:
+			return nil, fmt.Errorf("line %d: expected \"p, role, resource, action[, org]\" or \"g, subject, role\"", line)
+		}
+	}
+	return policy, scanner.Err()
+}
+
+// roles returns every role the subjects hold, directly or through inherited roles
+func (p *accessPolicy) roles(subjects []string) []string {
+	held := map[string]bool{}
+	pending := slices.Clone(subjects)
+	for len(pending) > 0 {
+		subject := pending[0]
+		pending = pending[1:]
+		for _, binding := range p.Bindings {
+			if binding.Subject == subject && !held[binding.Role] {
+				held[binding.Role] = true
+				pending = append(pending, binding.Role)
+			}
+		}
+	}
+	roles := make([]string, 0, len(held))
+	for role := range held {
+		roles = append(roles, role)
+	}
+	slices.Sort(roles)
+	return roles
+}
+
+// grants returns the rules that apply to a caller holding roles and signing for org
+func (p *accessPolicy) grants(roles []string, org string) []policyRule {
+	var rules []policyRule
+	for _, rule := range p.Rules {
+		if slices.Contains(roles, rule.Role) && (rule.Org == "*" || rule.Org == org) {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+func (r policyRule) matches(resource, action string) bool {
+	return (r.Resource == "*" || r.Resource == resource) && (r.Action == "*" || r.Action == action)
+}
+
+// allows reports whether any of the subjects may perform action on resource
+func (p *accessPolicy) allows(subjects []string, org, resource, action string) bool {
+	for _, rule := range p.grants(p.roles(subjects), org) {
+		if rule.matches(resource, action) {
+			return true
+		}
+	}
+	return false
+}
+
+// defines reports whether any rule grants role, or any binding gives it
+func (p *accessPolicy) defines(role string) bool {
+	return slices.ContainsFunc(p.Rules, func(r policyRule) bool { return r.Role == role }) ||
+		slices.ContainsFunc(p.Bindings, func(b roleBinding) bool { return b.Role == role })
+}
+
+// rebindSubject drops subject's "g" lines from a policy file and, unless role is empty, appends one
+// binding it to role. Comments and every other line are kept as written.
+func rebindSubject(text, subject, role string) string {
+	var lines []string
+	for _, line := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+		fields := strings.Split(line, ",")
+		if len(fields) == 3 && strings.TrimSpace(fields[0]) == "g" && strings.TrimSpace(fields[1]) == subject {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if role != "" {
+		lines = append(lines, fmt.Sprintf("g, %s, %s", subject, role))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// accessControl holds the policy currently enforced. A nil accessControl allows every request.
+type accessControl struct {
+	file string
+
+	mu     sync.RWMutex
+	policy *accessPolicy
+	// edits serializes read-modify-write changes to the policy, such as role bindings
+	edits sync.Mutex
+}
+
+var access *accessControl
+
+// initAccessControl enforces the policy in RBAC_POLICY_FILE on every REST, GraphQL and gRPC call
+func initAccessControl() {
+	file := getEnv("RBAC_POLICY_FILE", "")
+	if file == "" {
+		log.Println("🛂 RBAC_POLICY_FILE not set, every authenticated caller may use every route")
+		return
+	}
+	ac := &accessControl{file: file}
+	if err := ac.reload(); err != nil {
+		panic(fmt.Errorf("failed to load access policy: %w", err))
+	}
+	access = ac
+}
+
+// reload reads the policy file, keeping the enforced policy if the file is invalid
+func (ac *accessControl) reload() error {
+	data, err := os.ReadFile(ac.file)
+	if err != nil {
+		return err
+	}
+	policy, err := parseAccessPolicy(string(data))
+	if err != nil {
+		return err
+	}
+	ac.mu.Lock()
+	ac.policy = policy
+	ac.mu.Unlock()
+	log.Printf("🛂 Loaded %d access rules and %d role bindings from %s", len(policy.Rules), len(policy.Bindings), ac.file)
+	return nil
+}
+
+// replace enforces policy and writes it to the policy file, so it survives a restart
+func (ac *accessControl) replace(policy *accessPolicy) error {
+	if err := writeFileAtomic(ac.file, []byte(policy.text)); err != nil {
+		return err
+	}
+	ac.mu.Lock()
+	ac.policy = policy
+	ac.mu.Unlock()
+	return nil
+}
+
+// bindRole gives subject role in place of its current roles, or with role "" removes its bindings,
+// and saves the policy
+func (ac *accessControl) bindRole(subject, role string) (*accessPolicy, error) {
+	ac.edits.Lock()
+	defer ac.edits.Unlock()
+	policy, err := parseAccessPolicy(rebindSubject(ac.current().text, subject, role))
+	if err != nil {
+		return nil, err
+	}
+	if err := ac.replace(policy); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+func (ac *accessControl) current() *accessPolicy {
+	ac.mu.RLock()
+	defer ac.mu.RUnlock()
+	return ac.policy
+}
+
+// watchSIGHUP reloads the policy file whenever the process receives SIGHUP
+func (ac *accessControl) watchSIGHUP(ctx context.Context) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-signals:
+			if err := ac.reload(); err != nil {
+				log.Printf("⚠️  Access policy reload failed, keeping previous policy: %v", err)
+			}
+		}
+	}
+}
+
+// allows checks the request's caller against the enforced policy
+func (ac *accessControl) allows(ctx context.Context, resource, action string) bool {
+	if ac == nil {
+		return true
+	}
+	subjects, org := callerSubjects(ctx)
+	return ac.current().allows(subjects, org, resource, action)
+}
+
+// callerSubjects names the request's caller for role bindings, from the identity it signs as
+func callerSubjects(ctx context.Context) ([]string, string) {
+	org := mspID
+	subjects := []string{}
+	if id, ok := ctx.Value(signerContextKey{}).(*walletIdentity); ok && id != nil {
+		org = id.MSPID
+		subjects = append(subjects, "wallet:"+id.Label)
+	}
+	if caller := clientCertFromContext(ctx); caller != nil {
+		subjects = append(subjects, "cert:"+caller.Name)
+	}
+	return append(subjects, "org:"+org, "*"), org
+}
+
+// routePermission names the resource and action a REST route is checked against: the first path
+// segment under /api/v1, and the action its method implies
+func routePermission(method, route string) (string, string) {
+	if action, ok := routeActions[method+" "+route]; ok {
+		return routeResource(route), action
+	}
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch:
+		return routeResource(route), actionWrite
+	case http.MethodDelete:
+		return routeResource(route), actionDelete
+	}
+	return routeResource(route), actionRead
+}
+
+func routeResource(route string) string {
+	route = strings.TrimPrefix(route, "/api/v1")
+	resource, _, _ := strings.Cut(strings.TrimPrefix(route, "/"), "/")
+	return resource
+}
+
+// authorizeMiddleware rejects requests the caller's roles do not grant. Runs after the signer
+// middleware, which identifies the caller.
+func authorizeMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if access == nil {
+			c.Next()
+			return
+		}
+		resource, action := routePermission(c.Request.Method, c.FullPath())
+		if !access.allows(c.Request.Context(), resource, action) {
+			respondError(c, http.StatusForbidden, errCodeAccessDenied, fmt.Sprintf("Your roles do not allow %s on %s", action, resource))
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// grpcAuthorizeInterceptor applies the REST policy to the matching RPCs
+func grpcAuthorizeInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := grpcAuthorize(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// grpcAuthorizeStreamInterceptor checks a stream's permission when it is opened
+func grpcAuthorizeStreamInterceptor(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := grpcAuthorize(stream.Context(), info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, stream)
+}
+
+func grpcAuthorize(ctx context.Context, fullMethod string) error {
+	if access == nil {
+		return nil
+	}
+	resource, action := grpcPermission(fullMethod)
+	if !access.allows(ctx, resource, action) {
+		return status.Errorf(codes.PermissionDenied, "Your roles do not allow %s on %s", action, resource)
+	}
+	return nil
+}
+
+// grpcPermission maps an RPC to its REST resource and action, such as ListEvidenceByIncident to
+// evidence read
+func grpcPermission(fullMethod string) (string, string) {
+	name := fullMethod[strings.LastIndex(fullMethod, "/")+1:]
+	resource := ""
+	for _, candidate := range []struct{ noun, resource string }{
+		{"Evidence", "evidence"}, {"Audit", "audit"}, {"Incident", "incident"}, {"DID", "did"}, {"Location", "location"},
+	} {
+		if strings.Contains(name, candidate.noun) {
+			resource = candidate.resource
+			break
+		}
+	}
+	if name == "StreamLocation" {
+		// Uploads pings, as POST /location/pings does
+		return resource, actionWrite
+	}
+	switch grpcAuditMethod(fullMethod) {
+	case http.MethodPost, http.MethodPut:
+		return resource, actionWrite
+	case http.MethodDelete:
+		return resource, actionDelete
+	}
+	return resource, actionRead
+}
+
+// Admin API
+
+type AccessPolicyRequest struct {
+	// Policy is the whole policy file, in the same layout as RBAC_POLICY_FILE
+	Policy string `json:"policy" binding:"required"`
+}
+
+type PermissionsRequest struct {
+	Wallet string `form:"wallet"`
+	Org    string `form:"org"`
+}
+
+func (r PermissionsRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	if r.Wallet != "" {
+		v.identifier("wallet", r.Wallet)
+	}
+	if r.Org != "" {
+		v.identifier("org", r.Org)
+	}
+	return v.errors
+}
+
+// RoutePermission reports whether a caller may use one route
+type RoutePermission struct {
+	Method   string `json:"method"`
+	Path     string `json:"path"`
+	Resource string `json:"resource"`
+	Action   string `json:"action"`
+	Allowed  bool   `json:"allowed"`
+}
+
+// EffectivePermissions is what a caller's roles grant
+type EffectivePermissions struct {
+	Subjects []string          `json:"subjects"`
+	Org      string            `json:"org"`
+	Roles    []string          `json:"roles"`
+	Rules    []policyRule      `json:"rules"`
+	Routes   []RoutePermission `json:"routes"`
+}
+
+func (p *accessPolicy) effective(subjects []string, org string) EffectivePermissions {
+	roles := p.roles(subjects)
+	result := EffectivePermissions{Subjects: subjects, Org: org, Roles: roles, Rules: p.grants(roles, org), Routes: []RoutePermission{}}
+	if result.Rules == nil {
+		result.Rules = []policyRule{}
+	}
+	for _, op := range apiOperations {
+		resource, action := routePermission(op.method, "/api/v1"+op.path)
+		result.Routes = append(result.Routes, RoutePermission{
+			Method: op.method, Path: "/api/v1" + op.path, Resource: resource, Action: action,
+			Allowed: p.allows(subjects, org, resource, action),
+		})
+	}
+	return result
+}
+
+// respondAccessDisabled answers admin calls when no policy is enforced
+func respondAccessDisabled(c *gin.Context) bool {
+	if access != nil {
+		return false
+	}
+	respondError(c, http.StatusNotFound, errCodeNotFound, "Access policies are not enabled; set RBAC_POLICY_FILE")
+	return true
+}
+
+func getAccessPolicy(c *gin.Context) {
+	if respondAccessDisabled(c) {
+		return
+	}
+	respondData(c, http.StatusOK, access.current())
+}
+
+func updateAccessPolicy(c *gin.Context) {
+	if respondAccessDisabled(c) {
+		return
+	}
+	var req AccessPolicyRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+	policy, err := parseAccessPolicy(req.Policy)
+	if err != nil {
+		respondValidationErrors(c, ValidationErrors{{Field: "policy", Message: err.Error()}})
+		return
+	}
+	// Refuse policies that would leave nobody able to repair them through this API
+	subjects, org := callerSubjects(c.Request.Context())
+	if !policy.allows(subjects, org, "admin", actionWrite) {
+		respondValidationErrors(c, ValidationErrors{{Field: "policy", Message: "must keep admin write for the caller"}})
+		return
+	}
+	if err := access.replace(policy); err != nil {
+		respondServiceError(c, "Failed to save access policy", err)
+		return
+	}
+	log.Printf("🛂 Access policy replaced through the admin API: %d rules, %d role bindings", len(policy.Rules), len(policy.Bindings))
+	respondData(c, http.StatusOK, policy)
+}
+
+func reloadAccessPolicy(c *gin.Context) {
+	if respondAccessDisabled(c) {
+		return
+	}
+	if err := access.reload(); err != nil {
+		respondServiceError(c, "Failed to reload access policy", err)
+		return
+	}
+	respondData(c, http.StatusOK, access.current())
+}
+
+// getPermissions shows what a wallet identity or organization may do; without either, the caller
+func getPermissions(c *gin.Context) {
+	if respondAccessDisabled(c) {
+		return
+	}
+	var req PermissionsRequest
+	if !bindQuery(c, &req) {
+		return
+	}
+
+	subjects, org := callerSubjects(c.Request.Context())
+	if req.Wallet != "" {
+		var id *walletIdentity
+		if callerWallet != nil {
+			id = callerWallet.identity(req.Wallet)
+		}
+		if id == nil {
+			respondError(c, http.StatusNotFound, errCodeNotFound, fmt.Sprintf("The wallet identity %s does not exist", req.Wallet))
+			return
+		}
+		subjects, org = callerSubjects(withSigner(c.Request.Context(), id))
+	} else if req.Org != "" {
+		subjects, org = []string{"org:" + req.Org, "*"}, req.Org
+	}
+	respondData(c, http.StatusOK, access.current().effective(subjects, org))
+}
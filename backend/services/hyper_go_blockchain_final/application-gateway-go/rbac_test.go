@@ -0,0 +1,173 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+const testPolicy = `
+# district officers read and write their own district's records
+p, officer, incident, read
+p, officer, incident, write, Org2MSP
+p, officer, evidence, *
+p, supervisor, incident, delete
+p, control-room, tenancy, all
+p, admin, *, *
+g, wallet:shillong, supervisor
+g, supervisor, officer
+g, org:Org3MSP, control-room
+g, wallet:ops, admin
+`
+
+func TestAccessPolicy(t *testing.T) {
+	for _, bad := range []string{"p, officer, incident", "x, officer, incident, read", "g, wallet:a, , officer", "g, wallet:a"} {
+		if _, err := parseAccessPolicy(bad); err == nil {
+			t.Errorf("expected %q rejected", bad)
+		}
+	}
+	policy, err := parseAccessPolicy(testPolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	shillong := []string{"wallet:shillong", "org:Org2MSP", "*"}
+	if roles := strings.Join(policy.roles(shillong), ","); roles != "officer,supervisor" {
+		t.Errorf("expected the inherited officer role, got %s", roles)
+	}
+	for _, tc := range []struct {
+		subjects         []string
+		org              string
+		resource, action string
+		want             bool
+	}{
+		{shillong, "Org2MSP", "incident", actionDelete, true},
+		{shillong, "Org2MSP", "incident", actionWrite, true},
+		{shillong, "Org2MSP", "evidence", actionExport, true},
+		{shillong, "Org2MSP", "audit", actionRead, false},
+		{[]string{"wallet:tura", "org:Org1MSP", "*"}, "Org1MSP", "incident", actionRead, false},
+		{[]string{"wallet:shillong", "org:Org1MSP", "*"}, "Org1MSP", "incident", actionWrite, false},
+		{[]string{"org:Org3MSP", "*"}, "Org3MSP", "tenancy", "all", true},
+		{[]string{"wallet:ops", "org:Org1MSP", "*"}, "Org1MSP", "admin", actionWrite, true},
+	} {
+		if got := policy.allows(tc.subjects, tc.org, tc.resource, tc.action); got != tc.want {
+			t.Errorf("%v %s %s: expected %v", tc.subjects, tc.resource, tc.action, tc.want)
+		}
+	}
+
+	for route, want := range map[string]string{
+		"GET /api/v1/incident/:id":    "incident read",
+		"PUT /api/v1/incident/:id":    "incident write",
+		"DELETE /api/v1/evidence/:id": "evidence delete",
+		"GET /api/v1/incident/export": "incident export",
+		"POST /api/v1/did/verify-qr":  "did read",
+		"POST /graphql":               "graphql read",
+		"PUT /api/v1/admin/policy":    "admin write",
+	} {
+		method, path, _ := strings.Cut(route, " ")
+		if resource, action := routePermission(method, path); resource+" "+action != want {
+			t.Errorf("%s: expected %s, got %s %s", route, want, resource, action)
+		}
+	}
+	for method, want := range map[string]string{
+		"/sih.v1.LedgerService/ListEvidenceByIncident": "evidence read",
+		"/sih.v1.LedgerService/UpdateDID":              "did write",
+		"/sih.v1.LedgerService/DeleteIncident":         "incident delete",
+		"/sih.v1.LedgerService/SearchAudits":           "audit read",
+		"/sih.v1.LocationService/StreamLocation":       "location write",
+	} {
+		if resource, action := grpcPermission(method); resource+" "+action != want {
+			t.Errorf("%s: expected %s, got %s %s", method, want, resource, action)
+		}
+	}
+}
+
+func TestAccessControlAdminAPI(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "policy.csv")
+	os.WriteFile(file, []byte(testPolicy), 0o600)
+	access = &accessControl{file: file}
+	if err := access.reload(); err != nil {
+		t.Fatal(err)
+	}
+	callerWallet = &wallet{identities: map[string]*walletIdentity{
+		"shillong": {Label: "shillong", MSPID: "Org2MSP"},
+		"ops":      {Label: "ops", MSPID: "Org1MSP"},
+	}}
+	defer func() { access, callerWallet = nil, nil }()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	var caller *walletIdentity
+	api := r.Group("/api/v1", func(c *gin.Context) {
+		c.Request = c.Request.WithContext(withSigner(c.Request.Context(), caller))
+	}, authorizeMiddleware())
+	api.DELETE("/incident/:id", func(c *gin.Context) { c.Status(http.StatusOK) })
+	api.GET("/audit", func(c *gin.Context) { c.Status(http.StatusOK) })
+	api.PUT("/admin/policy", updateAccessPolicy)
+	api.GET("/admin/permissions", getPermissions)
+
+	send := func(method, path, body string) (int, APIResponse) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var response APIResponse
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w.Code, response
+	}
+
+	caller = callerWallet.identities["shillong"]
+	if status, _ := send(http.MethodDelete, "/api/v1/incident/inc-1", ""); status != http.StatusOK {
+		t.Errorf("expected the supervisor allowed to delete, got %d", status)
+	}
+	if status, response := send(http.MethodGet, "/api/v1/audit", ""); status != http.StatusForbidden || response.Error.Code != errCodeAccessDenied {
+		t.Errorf("expected audit reads denied, got %d %+v", status, response.Error)
+	}
+	tenancy.enabled = true
+	if tenantFromContext(withSigner(context.Background(), caller)).all {
+		t.Error("expected a district officer scoped to its organization")
+	}
+	if !tenantFromContext(withSigner(context.Background(), &walletIdentity{MSPID: "Org3MSP"})).all {
+		t.Error("expected the control room to see every organization")
+	}
+	tenancy.enabled = false
+
+	caller = callerWallet.identities["ops"]
+	status, response := send(http.MethodGet, "/api/v1/admin/permissions?wallet=shillong", "")
+	if status != http.StatusOK {
+		t.Fatalf("expected the effective permissions, got %d %+v", status, response.Error)
+	}
+	data, _ := json.Marshal(response.Data)
+	var permissions EffectivePermissions
+	json.Unmarshal(data, &permissions)
+	allowed := map[string]bool{}
+	for _, route := range permissions.Routes {
+		allowed[route.Method+" "+route.Path] = route.Allowed
+	}
+	if strings.Join(permissions.Roles, ",") != "officer,supervisor" || !allowed["DELETE /api/v1/incident/:id"] || allowed["GET /api/v1/audit"] {
+		t.Errorf("unexpected permissions for shillong: roles %v, routes %v", permissions.Roles, allowed)
+	}
+
+	lockout, _ := json.Marshal(AccessPolicyRequest{Policy: "p, officer, *, read\ng, wallet:ops, officer\n"})
+	if status, response := send(http.MethodPut, "/api/v1/admin/policy", string(lockout)); status != http.StatusBadRequest {
+		t.Errorf("expected a policy removing the caller's admin access refused, got %d %+v", status, response.Error)
+	}
+	updated := testPolicy + "p, officer, audit, read\n"
+	body, _ := json.Marshal(AccessPolicyRequest{Policy: updated})
+	if status, response := send(http.MethodPut, "/api/v1/admin/policy", string(body)); status != http.StatusOK {
+		t.Fatalf("expected the policy replaced, got %d %+v", status, response.Error)
+	}
+	if saved, _ := os.ReadFile(file); string(saved) != updated {
+		t.Errorf("expected the policy saved to RBAC_POLICY_FILE, got %q", saved)
+	}
+	caller = callerWallet.identities["shillong"]
+	if status, _ := send(http.MethodGet, "/api/v1/audit", ""); status != http.StatusOK {
+		t.Errorf("expected the new rule enforced at once, got %d", status)
+	}
+}
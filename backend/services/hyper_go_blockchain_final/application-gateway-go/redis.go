@@ -0,0 +1,145 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// errRedisNil is returned when a key does not exist
+var errRedisNil error = redis.Nil
+
+// Redis deployments REDIS_MODE selects
+const (
+	redisStandalone = "standalone"
+	redisCluster    = "cluster"
+	redisSentinel   = "sentinel"
+)
+
+// redisClient runs commands through go-redis, which pools connections and follows cluster and
+// Sentinel topology. Replies keep the RESP2 shapes the stores decode: bulk strings as []byte,
+// integers as int64 and arrays as []interface{}.
+type redisClient struct {
+	addr   string
+	client redis.UniversalClient
+}
+
+// newRedisClient takes a redis:// or, for TLS, rediss:// URL in go-redis's format. For a cluster
+// or a Sentinel set the URL's host is the first node and further nodes are added with addr
+// parameters; a Sentinel URL also names the master with master_name.
+func newRedisClient(rawURL, mode string, poolSize int, timeout time.Duration, caFile string) (*redisClient, error) {
+	var opts redis.UniversalOptions
+	switch mode {
+	case redisStandalone, "":
+		o, err := redis.ParseURL(rawURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redis URL: %w", err)
+		}
+		opts = redis.UniversalOptions{Addrs: []string{o.Addr}, Username: o.Username, Password: o.Password, DB: o.DB, TLSConfig: o.TLSConfig}
+	case redisCluster:
+		o, err := redis.ParseClusterURL(rawURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redis cluster URL: %w", err)
+		}
+		opts = redis.UniversalOptions{Addrs: o.Addrs, Username: o.Username, Password: o.Password, TLSConfig: o.TLSConfig, IsClusterMode: true}
+	case redisSentinel:
+		o, err := redis.ParseFailoverURL(rawURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redis Sentinel URL: %w", err)
+		}
+		if o.MasterName == "" {
+			return nil, errors.New("redis Sentinel URL must name the master with master_name")
+		}
+		// The URL's credentials serve the Sentinels and the master alike
+		opts = redis.UniversalOptions{
+			Addrs: o.SentinelAddrs, MasterName: o.MasterName, DB: o.DB, TLSConfig: o.TLSConfig,
+			SentinelUsername: o.SentinelUsername, SentinelPassword: o.SentinelPassword, Username: o.SentinelUsername, Password: o.SentinelPassword,
+		}
+	default:
+		return nil, fmt.Errorf("REDIS_MODE must be %s, %s or %s", redisStandalone, redisCluster, redisSentinel)
+	}
+
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read redis CA: %w", err)
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", caFile)
+		}
+		if opts.TLSConfig == nil {
+			return nil, errors.New("a redis CA needs a rediss:// URL")
+		}
+		opts.TLSConfig.RootCAs = roots
+	}
+	if opts.TLSConfig != nil && opts.TLSConfig.MinVersion < tls.VersionTLS12 {
+		opts.TLSConfig.MinVersion = tls.VersionTLS12
+	}
+	opts.Protocol = 2
+	opts.PoolSize = poolSize
+	opts.DialTimeout, opts.ReadTimeout, opts.WriteTimeout = timeout, timeout, timeout
+	opts.ContextTimeoutEnabled = true
+
+	return &redisClient{addr: strings.Join(opts.Addrs, ","), client: redis.NewUniversalClient(&opts)}, nil
+}
+
+// Do sends one command and returns its reply
+func (r *redisClient) Do(ctx context.Context, args ...string) (interface{}, error) {
+	cmd := make([]interface{}, len(args))
+	for i, arg := range args {
+		cmd[i] = arg
+	}
+	reply, err := r.client.Do(ctx, cmd...).Result()
+	if err != nil {
+		return nil, err
+	}
+	return redisReply(reply), nil
+}
+
+// redisReply turns go-redis's strings back into the byte slices RESP2 bulk strings decode to
+func redisReply(reply interface{}) interface{} {
+	switch v := reply.(type) {
+	case string:
+		return []byte(v)
+	case []interface{}:
+		for i, item := range v {
+			v[i] = redisReply(item)
+		}
+	}
+	return reply
+}
+
+func (r *redisClient) Get(ctx context.Context, key string) ([]byte, error) {
+	return r.client.Get(ctx, key).Bytes()
+}
+
+func (r *redisClient) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return r.client.Set(ctx, key, value, ttl).Err()
+}
+
+func (r *redisClient) Del(ctx context.Context, keys ...string) error {
+	return r.client.Del(ctx, keys...).Err()
+}
+
+func (r *redisClient) Ping(ctx context.Context) error {
+	return r.client.Ping(ctx).Err()
+}
+
+// Close closes the pooled connections
+func (r *redisClient) Close() error {
+	return r.client.Close()
+}
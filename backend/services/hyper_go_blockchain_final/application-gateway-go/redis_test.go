@@ -0,0 +1,91 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestNewRedisClient(t *testing.T) {
+	client, err := newRedisClient("redis://:secret@cache/2", redisStandalone, 4, time.Second, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	opts := client.client.(*redis.Client).Options()
+	if client.addr != "cache:6379" || opts.Password != "secret" || opts.DB != 2 || opts.PoolSize != 4 || opts.Protocol != 2 || opts.TLSConfig != nil {
+		t.Errorf("got addr=%q %+v", client.addr, opts)
+	}
+
+	client, err = newRedisClient("rediss://cache-0:6379?addr=cache-1:6379&addr=cache-2:6379", redisCluster, 4, time.Second, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cluster := client.client.(*redis.ClusterClient).Options()
+	if len(cluster.Addrs) != 3 || cluster.TLSConfig == nil || cluster.TLSConfig.ServerName != "cache-0" {
+		t.Errorf("unexpected cluster options %+v", cluster)
+	}
+
+	client, err = newRedisClient("redis://:secret@sentinel-0:26379?addr=sentinel-1:26379&master_name=sih", redisSentinel, 4, time.Second, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := client.client.(*redis.Client); !ok || client.addr != "sentinel-0:26379,sentinel-1:26379" {
+		t.Errorf("expected a failover client for %s, got %T", client.addr, client.client)
+	}
+
+	for _, bad := range []struct{ url, mode string }{
+		{"http://cache:6379", redisStandalone},
+		{"redis://sentinel-0:26379", redisSentinel},
+		{"redis://cache:6379", "replicated"},
+	} {
+		if _, err := newRedisClient(bad.url, bad.mode, 4, time.Second, ""); err == nil {
+			t.Errorf("expected %s in %s mode to be rejected", bad.url, bad.mode)
+		}
+	}
+	if _, err := newRedisClient("redis://cache:6379", redisStandalone, 4, time.Second, "/nonexistent/ca.pem"); err == nil {
+		t.Error("expected a missing CA file to be rejected")
+	}
+}
+
+func TestRedisReplies(t *testing.T) {
+	client := startTestRedis(t)
+	ctx := context.Background()
+
+	if err := client.Set(ctx, "greeting", []byte("hello"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if reply, err := client.Do(ctx, "GET", "greeting"); err != nil || string(reply.([]byte)) != "hello" {
+		t.Errorf("bulk string: got %v, %v", reply, err)
+	}
+	if _, err := client.Get(ctx, "missing"); !errors.Is(err, errRedisNil) {
+		t.Errorf("missing key: got %v", err)
+	}
+	if _, err := client.Do(ctx, "LPUSH", "greeting", "x"); err == nil || !strings.Contains(err.Error(), "WRONGTYPE") {
+		t.Errorf("error reply: got %v", err)
+	}
+	if reply, err := client.Do(ctx, "SADD", "set", "a", "b", "c"); err != nil || reply.(int64) != 3 {
+		t.Errorf("integer: got %v, %v", reply, err)
+	}
+	client.Do(ctx, "HSET", "hash", "field", "value")
+	reply, err := client.Do(ctx, "HGETALL", "hash")
+	if items, ok := reply.([]interface{}); err != nil || !ok || len(items) != 2 || string(items[1].([]byte)) != "value" {
+		t.Errorf("array: got %#v, %v", reply, err)
+	}
+}
+
+// startTestRedis runs an in-memory Redis for testing the Redis-backed stores
+func startTestRedis(t *testing.T) *redisClient {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client, err := newRedisClient("redis://"+server.Addr(), redisStandalone, 2, time.Second, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
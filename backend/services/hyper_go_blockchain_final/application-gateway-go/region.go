@@ -0,0 +1,246 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/fabric-gateway/pkg/identity"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/resolver/manual"
+)
+
+const (
+	regionalScheme = "fabric-peers"
+	peerProbeDial  = 3 * time.Second
+)
+
+// peerRegions places peers in regions so each gateway replica prefers the peers of its own
+// GATEWAY_REGION. Peers are named by their gossip endpoint, as discovery reports them.
+type peerRegions struct {
+	local      string
+	byEndpoint map[string]string
+	// order is the configured peers in FABRIC_PEERS order
+	order []string
+}
+
+var regions peerRegions
+
+// parsePeerRegions reads FABRIC_PEERS, "host:port@region" entries where the region is optional
+func parsePeerRegions(local, value string) (peerRegions, error) {
+	r := peerRegions{local: local, byEndpoint: map[string]string{}}
+	for _, item := range splitList(value) {
+		endpoint, region, _ := strings.Cut(item, "@")
+		endpoint, region = strings.TrimSpace(endpoint), strings.TrimSpace(region)
+		if _, _, err := net.SplitHostPort(endpoint); err != nil {
+			return peerRegions{}, fmt.Errorf("%q must be host:port, optionally followed by @region", item)
+		}
+		if _, ok := r.byEndpoint[endpoint]; ok {
+			return peerRegions{}, fmt.Errorf("%s is listed twice", endpoint)
+		}
+		r.byEndpoint[endpoint] = region
+		r.order = append(r.order, endpoint)
+	}
+	return r, nil
+}
+
+// preferred reports whether a peer is in this replica's region. Without GATEWAY_REGION no peer is.
+func (r peerRegions) preferred(endpoint string) bool {
+	return r.local != "" && r.byEndpoint[endpoint] == r.local
+}
+
+// endpoints lists the configured peers, those in the local region first, then the bootstrap peer
+// when it is not among them
+func (r peerRegions) endpoints(asLocalhost bool, bootstrap GatewayEndpoint) []GatewayEndpoint {
+	endpoints := make([]GatewayEndpoint, 0, len(r.order)+1)
+	for _, endpoint := range r.order {
+		host, port, _ := net.SplitHostPort(endpoint)
+		address := endpoint
+		if asLocalhost {
+			address = net.JoinHostPort("localhost", port)
+		}
+		endpoints = append(endpoints, GatewayEndpoint{Address: address, ServerName: host, Region: r.byEndpoint[endpoint]})
+	}
+	slices.SortStableFunc(endpoints, func(a, b GatewayEndpoint) int {
+		return boolOrder(r.local != "" && a.Region == r.local, r.local != "" && b.Region == r.local)
+	})
+	if !slices.ContainsFunc(endpoints, func(e GatewayEndpoint) bool { return e.Address == bootstrap.Address }) {
+		endpoints = append(endpoints, bootstrap)
+	}
+	return endpoints
+}
+
+// boolOrder sorts true before false
+func boolOrder(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case a:
+		return -1
+	default:
+		return 1
+	}
+}
+
+// peerRouter points the gateway connection at an ordered list of peers. gRPC's pick_first fails
+// over down the list when the peer in use goes away, but then stays on whichever peer it reached.
+// The router fails back: once a local peer that was unreachable answers again, it offers only the
+// local peers for a round, which moves the connection home, then the whole list again.
+type peerRouter struct {
+	resolver *manual.Resolver
+	interval time.Duration
+
+	mu        sync.Mutex
+	endpoints []GatewayEndpoint
+	// localDown is set when every local peer failed a probe, so the connection has left them
+	localDown bool
+	pinned    bool
+	failovers int
+}
+
+var peerRouting *peerRouter
+
+func newPeerRouter(scheme string, endpoints []GatewayEndpoint) *peerRouter {
+	p := &peerRouter{
+		resolver:  manual.NewBuilderWithScheme(scheme),
+		interval:  getEnvDuration("FABRIC_FAILBACK_INTERVAL", 30*time.Second),
+		endpoints: endpoints,
+	}
+	p.resolver.InitialState(resolver.State{Addresses: resolverAddresses(endpoints)})
+	return p
+}
+
+// update replaces the peers, as a discovery round finds them
+func (p *peerRouter) update(endpoints []GatewayEndpoint) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.endpoints = endpoints
+	if !p.pinned {
+		p.resolver.UpdateState(resolver.State{Addresses: resolverAddresses(endpoints)})
+	}
+}
+
+func (p *peerRouter) snapshot() []GatewayEndpoint {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.endpoints
+}
+
+// newRegionalConnection connects through the FABRIC_PEERS list without discovery. Every peer must
+// present a certificate from the gateway peer's TLS CA or one of FABRIC_PEER_TLS_CA_FILES.
+func newRegionalConnection(tlsCA *x509.Certificate) *grpc.ClientConn {
+	roots := x509.NewCertPool()
+	roots.AddCert(tlsCA)
+	for _, file := range splitList(getEnv("FABRIC_PEER_TLS_CA_FILES", "")) {
+		pem, err := os.ReadFile(file)
+		if err != nil {
+			panic(fmt.Errorf("failed to read FABRIC_PEER_TLS_CA_FILES: %w", err))
+		}
+		if _, err := identity.CertificateFromPEM(pem); err != nil {
+			panic(fmt.Errorf("%s is not a PEM certificate: %w", file, err))
+		}
+		roots.AppendCertsFromPEM(pem)
+	}
+
+	endpoints := regions.endpoints(getEnvBool("FABRIC_DISCOVERY_AS_LOCALHOST", false), bootstrapEndpoint())
+	peerRouting = newPeerRouter(regionalScheme, endpoints)
+	connection, err := grpc.NewClient(regionalScheme+":///gateway",
+		grpc.WithResolvers(peerRouting.resolver),
+		// Each address carries its peer's server name, which the handshake verifies
+		grpc.WithTransportCredentials(credentials.NewClientTLSFromCert(roots, "")),
+		grpc.WithDefaultServiceConfig(`{"loadBalancingConfig":[{"pick_first":{}}]}`),
+	)
+	if err != nil {
+		panic(fmt.Errorf("failed to create gRPC connection: %w", err))
+	}
+	log.Printf("🔀 Connecting through %d peers, preferring region %s", len(endpoints), cmpOr(regions.local, "none"))
+	return connection
+}
+
+// run probes the local peers every FABRIC_FAILBACK_INTERVAL until ctx is done
+func (p *peerRouter) run(ctx context.Context) {
+	if p.interval <= 0 || regions.local == "" {
+		return
+	}
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.failback(ctx, probePeer)
+		}
+	}
+}
+
+// failback runs one probe round. With no local peer, or only local peers, there is nothing to
+// fail back to.
+func (p *peerRouter) failback(ctx context.Context, probe func(ctx context.Context, address string) bool) {
+	p.mu.Lock()
+	var local []GatewayEndpoint
+	for _, e := range p.endpoints {
+		if regions.local != "" && e.Region == regions.local {
+			local = append(local, e)
+		}
+	}
+	if len(local) == 0 || len(local) == len(p.endpoints) {
+		p.mu.Unlock()
+		return
+	}
+	if p.pinned {
+		// The connection moved home last round; offer the remote peers again for the next failover
+		p.resolver.UpdateState(resolver.State{Addresses: resolverAddresses(p.endpoints)})
+		p.pinned = false
+		p.mu.Unlock()
+		return
+	}
+	p.mu.Unlock()
+
+	// Probing takes a few seconds when peers are down, so discovery rounds are not held up meanwhile
+	up := slices.ContainsFunc(local, func(e GatewayEndpoint) bool { return probe(ctx, e.Address) })
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch {
+	case !up && !p.localDown:
+		p.localDown = true
+		p.failovers++
+		log.Printf("🔀 No peer in region %s answers, the connection fails over to remote peers", regions.local)
+	case up && p.localDown:
+		p.localDown, p.pinned = false, true
+		p.resolver.UpdateState(resolver.State{Addresses: resolverAddresses(local)})
+		log.Printf("🔀 A peer in region %s answers again, failing back to it", regions.local)
+	}
+}
+
+// probePeer reports whether a peer accepts TCP connections
+func probePeer(ctx context.Context, address string) bool {
+	conn, err := (&net.Dialer{Timeout: peerProbeDial}).DialContext(ctx, "tcp", address)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// status reports whether the connection has left the local region
+func (p *peerRouter) status() (bool, int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.localDown, p.failovers
+}
@@ -0,0 +1,81 @@
+package gateway
+
+import (
+	"context"
+	"testing"
+)
+
+func TestPeerRegions(t *testing.T) {
+	r, err := parsePeerRegions("ap-south-2", "peer0.org1.example.com:7051@ap-south-1, peer1.org1.example.com:8051@ap-south-2,peer0.org2.example.com:9051")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bootstrap := GatewayEndpoint{Address: "localhost:7051", ServerName: "peer0.org1.example.com", MSPID: "Org1MSP", Local: true}
+	endpoints := r.endpoints(true, bootstrap)
+	if len(endpoints) != 3 || endpoints[0].ServerName != "peer1.org1.example.com" || endpoints[0].Address != "localhost:8051" || endpoints[1].Region != "ap-south-1" {
+		t.Fatalf("expected the local region's peer first, then the rest in order, got %+v", endpoints)
+	}
+	bootstrap.Address = "gateway.example.com:7051"
+	if endpoints = r.endpoints(false, bootstrap); len(endpoints) != 4 || endpoints[3] != bootstrap {
+		t.Errorf("expected an unlisted bootstrap peer last, got %+v", endpoints)
+	}
+	for _, value := range []string{"peer0:7051@a,peer0:7051@b", "peer0@a"} {
+		if _, err := parsePeerRegions("a", value); err == nil {
+			t.Errorf("expected %q rejected", value)
+		}
+	}
+
+	// Discovered peers in the local region go ahead of the local organization's
+	topology := ChannelTopology{Channel: "mychannel", Peers: []DiscoveredPeer{
+		{Endpoint: "peer0.org1.example.com:7051", MSPID: "Org1MSP", LedgerHeight: 42},
+		{Endpoint: "peer1.org1.example.com:8051", MSPID: "Org1MSP", LedgerHeight: 42},
+		{Endpoint: "peer0.org2.example.com:9051", MSPID: "Org2MSP", LedgerHeight: 42},
+	}}
+	r.byEndpoint["peer0.org2.example.com:9051"] = "ap-south-2"
+	discovered := gatewayEndpoints([]ChannelTopology{topology}, "Org1MSP", r, false, bootstrap)
+	order := []string{"peer1.org1.example.com", "peer0.org2.example.com", "peer0.org1.example.com"}
+	for i, name := range order {
+		if discovered[i].ServerName != name {
+			t.Errorf("endpoint %d = %+v, expected %s", i, discovered[i], name)
+		}
+	}
+	if discovered[0].Region != "ap-south-2" || discovered[2].Region != "ap-south-1" {
+		t.Errorf("expected regions labelled, got %+v", discovered)
+	}
+}
+
+func TestPeerFailback(t *testing.T) {
+	saved := regions
+	defer func() { regions = saved }()
+	regions = peerRegions{local: "ap-south-2"}
+	p := newPeerRouter(regionalScheme, []GatewayEndpoint{
+		{Address: "peer1:8051", Region: "ap-south-2"},
+		{Address: "peer0:7051", Region: "ap-south-1"},
+	})
+
+	localUp := true
+	probe := func(_ context.Context, address string) bool { return address != "peer1:8051" || localUp }
+	p.failback(context.Background(), probe)
+	if down, failovers := p.status(); down || failovers != 0 || p.pinned {
+		t.Fatalf("expected nothing to do while the local peer answers, got down=%t failovers=%d", down, failovers)
+	}
+
+	localUp = false
+	p.failback(context.Background(), probe)
+	p.failback(context.Background(), probe)
+	if down, failovers := p.status(); !down || failovers != 1 {
+		t.Errorf("expected one failover while the local peer is down, got down=%t failovers=%d", down, failovers)
+	}
+
+	// Back up: the connection is pinned to the local peers for a round, then offered all of them
+	localUp = true
+	p.failback(context.Background(), probe)
+	if down, _ := p.status(); down || !p.pinned {
+		t.Error("expected the router pinned to the local region")
+	}
+	p.update([]GatewayEndpoint{{Address: "peer1:8051", Region: "ap-south-2"}, {Address: "peer0:7051", Region: "ap-south-1"}, {Address: "peer2:10051"}})
+	p.failback(context.Background(), probe)
+	if p.pinned || len(p.snapshot()) != 3 {
+		t.Errorf("expected the full list offered again with the discovered peer, got %+v", p.snapshot())
+	}
+}
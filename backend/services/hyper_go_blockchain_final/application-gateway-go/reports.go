@@ -0,0 +1,751 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/mail"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	reportDaily  = "daily"
+	reportWeekly = "weekly"
+
+	errCodeReportTampered = "REPORT_HASH_MISMATCH"
+
+	reportCheckInterval = 5 * time.Minute
+)
+
+var (
+	reportPeriods = []string{reportDaily, reportWeekly}
+	reportFormats = []string{"pdf", "csv"}
+)
+
+// ReportAnchor is a report's digests as the chaincode stores them
+type ReportAnchor struct {
+	ReportID    string `json:"report_id"`
+	Period      string `json:"period"`
+	PeriodStart string `json:"period_start"`
+	PeriodEnd   string `json:"period_end"`
+	PDFHash     string `json:"pdf_hash"`
+	CSVHash     string `json:"csv_hash"`
+	GeneratedBy string `json:"generated_by"`
+	AnchoredAt  string `json:"anchored_at"`
+	TxID        string `json:"tx_id"`
+}
+
+// OperationsReport is what a daily or weekly report says about its period
+type OperationsReport struct {
+	ReportID    string          `json:"report_id"`
+	Period      string          `json:"period"`
+	PeriodStart string          `json:"period_start"`
+	PeriodEnd   string          `json:"period_end"`
+	Timezone    string          `json:"timezone"`
+	GeneratedAt string          `json:"generated_at"`
+	Incidents   ReportIncidents `json:"incidents"`
+	Response    ReportResponse  `json:"response"`
+	DIDs        ReportDIDs      `json:"dids"`
+}
+
+// ReportIncidents counts the incidents created in the period. Districts are those of the nearest
+// unit, as on the police dashboard.
+type ReportIncidents struct {
+	Total      int            `json:"total"`
+	ByCategory map[string]int `json:"by_category"`
+	BySeverity map[string]int `json:"by_severity"`
+	ByDistrict map[string]int `json:"by_district"`
+}
+
+// ReportResponse measures the response to the period's incidents and dispatch assignments
+type ReportResponse struct {
+	Incidents   ReportSLA `json:"incidents"`
+	Assignments ReportSLA `json:"assignments"`
+	Escalated   int       `json:"assignments_escalated"`
+	Declined    int       `json:"assignments_declined"`
+}
+
+// ReportSLA is how many of Total were acknowledged, and how many of those within Target. The
+// times are null when nothing was acknowledged.
+type ReportSLA struct {
+	Total         int      `json:"total"`
+	Acknowledged  int      `json:"acknowledged"`
+	WithinTarget  int      `json:"within_target"`
+	Target        string   `json:"target"`
+	MedianSeconds *float64 `json:"median_seconds"`
+	P90Seconds    *float64 `json:"p90_seconds"`
+}
+
+// ReportDIDs counts the DIDs issued in the period
+type ReportDIDs struct {
+	Issued   int            `json:"issued"`
+	ByIssuer map[string]int `json:"by_issuer"`
+}
+
+// GenerateReportRequest generates the report for the period containing date, or for the last
+// completed period when date is empty
+type GenerateReportRequest struct {
+	Period string `json:"period" binding:"required"`
+	Date   string `json:"date"`
+}
+
+func (r GenerateReportRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.oneOf("period", r.Period, reportPeriods)
+	if r.Date != "" {
+		if _, err := time.Parse(time.DateOnly, r.Date); err != nil {
+			v.add("date", "must be a date like 2025-09-20")
+		}
+	}
+	return v.errors
+}
+
+// ReportListRequest names the period whose reports to list
+type ReportListRequest struct {
+	Period string `form:"period" binding:"required"`
+}
+
+func (r ReportListRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.oneOf("period", r.Period, reportPeriods)
+	return v.errors
+}
+
+// GeneratedReport is a report that was stored, anchored and sent to Emailed recipients
+type GeneratedReport struct {
+	Report  OperationsReport `json:"report"`
+	Anchor  ReportAnchor     `json:"anchor"`
+	Emailed int              `json:"emailed"`
+}
+
+// reportSource reads the documents a report covers, created in [from, to)
+type reportSource interface {
+	incidents(ctx context.Context, from, to time.Time) ([]IncidentDocument, error)
+	assignments(ctx context.Context, from, to time.Time) ([]AssignmentDocument, error)
+	dids(ctx context.Context, from, to time.Time) ([]DIDDocument, error)
+}
+
+// reportGenerator builds operations reports, stores their PDF and CSV next to the evidence files,
+// anchors both digests and emails them. It generates the REPORT_SCHEDULE periods on its own once
+// each has ended and REPORT_DELAY has passed, for late events to land.
+type reportGenerator struct {
+	source           reportSource
+	schedule         []string
+	location         *time.Location
+	delay            time.Duration
+	recipients       []string
+	incidentTarget   time.Duration
+	assignmentTarget time.Duration
+	actor            string
+
+	mu   sync.Mutex
+	done map[string]bool
+}
+
+var reports *reportGenerator
+
+// initReports runs after initEvidenceStore, initDocumentCache and initEmail
+func initReports() {
+	timezone := getEnv("REPORT_TIMEZONE", defaultZoneTimezone)
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		panic(fmt.Errorf("REPORT_TIMEZONE %q is not a known timezone", timezone))
+	}
+	reports = &reportGenerator{
+		source:           ledgerReportSource{},
+		schedule:         splitList(getEnv("REPORT_SCHEDULE", "")),
+		location:         location,
+		delay:            getEnvDuration("REPORT_DELAY", 15*time.Minute),
+		recipients:       splitList(getEnv("REPORT_RECIPIENTS", "")),
+		incidentTarget:   getEnvDuration("REPORT_INCIDENT_ACK_TARGET", 15*time.Minute),
+		assignmentTarget: getEnvDuration("REPORT_ASSIGNMENT_ACK_TARGET", 2*time.Minute),
+		actor:            getEnv("REPORT_ACTOR", "report-scheduler"),
+		done:             map[string]bool{},
+	}
+	for _, period := range reports.schedule {
+		if !slices.Contains(reportPeriods, period) {
+			panic(fmt.Errorf("REPORT_SCHEDULE must list %s, got %q", strings.Join(reportPeriods, " or "), period))
+		}
+	}
+	for _, recipient := range reports.recipients {
+		if address, err := mail.ParseAddress(recipient); err != nil || address.Address != recipient {
+			panic(fmt.Errorf("REPORT_RECIPIENTS has an invalid address %q", recipient))
+		}
+	}
+	if len(reports.schedule) == 0 {
+		log.Println("📊 REPORT_SCHEDULE not set, reports are only generated through the API")
+		return
+	}
+	if len(reports.recipients) > 0 && emailer == nil {
+		log.Println("📊 REPORT_RECIPIENTS is set but SMTP_HOST is not, reports will not be emailed")
+	}
+	log.Printf("📊 Generating %s reports in %s for %d recipients", strings.Join(reports.schedule, " and "), timezone, len(reports.recipients))
+}
+
+// reportPeriodAt returns the daily or weekly period containing t. Weeks start on Monday.
+func reportPeriodAt(period string, t time.Time, location *time.Location) (time.Time, time.Time) {
+	local := t.In(location)
+	start := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, location)
+	if period == reportDaily {
+		return start, start.AddDate(0, 0, 1)
+	}
+	start = start.AddDate(0, 0, -(int(start.Weekday())+6)%7)
+	return start, start.AddDate(0, 0, 7)
+}
+
+// reportID is deterministic, so each period has one report however many instances generate it
+func reportID(period string, start time.Time) string {
+	return "RPT:" + period + ":" + start.Format(time.DateOnly)
+}
+
+func reportStorageKey(anchor ReportAnchor, format string) string {
+	return fmt.Sprintf("reports/%s/%s.%s", anchor.Period, strings.TrimPrefix(anchor.ReportID, "RPT:"+anchor.Period+":"), format)
+}
+
+// generateDue generates each scheduled period's report once it is due; the scheduler checks every
+// reportCheckInterval. A report is claimed before it is generated, so with several gateway
+// instances only one generates it.
+func (g *reportGenerator) generateDue(ctx context.Context, now time.Time) error {
+	var failed []string
+	for _, period := range g.schedule {
+		current, _ := reportPeriodAt(period, now.Add(-g.delay), g.location)
+		start, _ := reportPeriodAt(period, current.Add(-time.Nanosecond), g.location)
+		id := reportID(period, start)
+
+		g.mu.Lock()
+		done := g.done[id]
+		g.mu.Unlock()
+		if done {
+			continue
+		}
+		if _, err := ledger.ReadReportAnchor(ctx, id); err == nil {
+			g.markDone(id)
+			continue
+		}
+		if claimed, err := claimEvent(ctx, "report:"+id, time.Hour); err != nil || !claimed {
+			continue
+		}
+		generated, err := g.generate(ctx, period, start)
+		if err != nil {
+			log.Printf("📊 Failed to generate report %s: %v", id, err)
+			failed = append(failed, id)
+			continue
+		}
+		g.markDone(id)
+		log.Printf("📊 Generated report %s in transaction %s, emailed to %d recipients", id, generated.Anchor.TxID, generated.Emailed)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to generate %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func (g *reportGenerator) markDone(id string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.done[id] = true
+}
+
+// generate builds the period's report, stores its PDF and CSV, anchors their digests and emails
+// them. Files are removed again when the anchor fails, so none is kept the ledger has no record of.
+func (g *reportGenerator) generate(ctx context.Context, period string, start time.Time) (*GeneratedReport, error) {
+	_, end := reportPeriodAt(period, start, g.location)
+	report, err := g.build(ctx, period, start, end)
+	if err != nil {
+		return nil, err
+	}
+	pdf := renderReportPDF(report)
+	csvData, err := renderReportCSV(report)
+	if err != nil {
+		return nil, err
+	}
+	pdfSum, csvSum := sha256.Sum256(pdf), sha256.Sum256(csvData)
+	anchor := ReportAnchor{
+		ReportID:    report.ReportID,
+		Period:      period,
+		PeriodStart: report.PeriodStart,
+		PeriodEnd:   report.PeriodEnd,
+		PDFHash:     hex.EncodeToString(pdfSum[:]),
+		CSVHash:     hex.EncodeToString(csvSum[:]),
+		GeneratedBy: g.actor,
+	}
+
+	files := map[string][]byte{"pdf": pdf, "csv": csvData}
+	contentTypes := map[string]string{"pdf": "application/pdf", "csv": "text/csv"}
+	var stored []string
+	removeStored := func() {
+		for _, key := range stored {
+			if err := evidenceStore.Delete(ctx, key); err != nil {
+				logWithContext(ctx, "Failed to remove orphaned report file %s: %v", key, err)
+			}
+		}
+	}
+	for _, format := range reportFormats {
+		key := reportStorageKey(anchor, format)
+		if err := evidenceStore.Put(ctx, key, bytes.NewReader(files[format]), int64(len(files[format])), contentTypes[format]); err != nil {
+			removeStored()
+			return nil, fmt.Errorf("failed to store report %s: %w", key, err)
+		}
+		stored = append(stored, key)
+	}
+	result, err := submitTransaction(ctx, "AnchorReport", anchor.ReportID, period, anchor.PeriodStart, anchor.PeriodEnd, anchor.PDFHash, anchor.CSVHash, g.actor)
+	if err != nil {
+		if translateFabricError(err).Code != errCodeAlreadyExists {
+			removeStored()
+		}
+		return nil, err
+	}
+	anchor.TxID = result.TxID
+	anchor.AnchoredAt = time.Now().UTC().Format(time.RFC3339)
+
+	generated := &GeneratedReport{Report: report, Anchor: anchor}
+	generated.Emailed = g.email(ctx, generated, files)
+	return generated, nil
+}
+
+// build reads the period's documents and summarizes them
+func (g *reportGenerator) build(ctx context.Context, period string, start, end time.Time) (OperationsReport, error) {
+	incidents, err := g.source.incidents(ctx, start, end)
+	if err != nil {
+		return OperationsReport{}, err
+	}
+	assignments, err := g.source.assignments(ctx, start, end)
+	if err != nil {
+		return OperationsReport{}, err
+	}
+	dids, err := g.source.dids(ctx, start, end)
+	if err != nil {
+		return OperationsReport{}, err
+	}
+
+	report := OperationsReport{
+		ReportID:    reportID(period, start),
+		Period:      period,
+		PeriodStart: start.Format(time.RFC3339),
+		PeriodEnd:   end.Format(time.RFC3339),
+		Timezone:    g.location.String(),
+		GeneratedAt: time.Now().UTC().Format(time.RFC3339),
+		Incidents:   ReportIncidents{ByCategory: map[string]int{}, BySeverity: map[string]int{}, ByDistrict: map[string]int{}},
+		DIDs:        ReportDIDs{ByIssuer: map[string]int{}},
+	}
+
+	var incidentTimes []float64
+	for _, incident := range incidents {
+		report.Incidents.Total++
+		report.Incidents.ByCategory[cmpOr(incident.Category, "uncategorized")]++
+		report.Incidents.BySeverity[cmpOr(incident.Severity, "unspecified")]++
+		report.Incidents.ByDistrict[districtOf(incident.Geohash)]++
+		if seconds, ok := secondsBetween(incident.CreatedAt, incident.AcknowledgedAt); ok {
+			incidentTimes = append(incidentTimes, seconds)
+		}
+	}
+	report.Response.Incidents = summarizeSLA(len(incidents), incidentTimes, g.incidentTarget)
+
+	var assignmentTimes []float64
+	for _, assignment := range assignments {
+		switch assignment.Status {
+		case "escalated":
+			report.Response.Escalated++
+		case "declined":
+			report.Response.Declined++
+		}
+		if seconds, ok := secondsBetween(assignment.AssignedAt, assignment.AcknowledgedAt); ok {
+			assignmentTimes = append(assignmentTimes, seconds)
+		}
+	}
+	report.Response.Assignments = summarizeSLA(len(assignments), assignmentTimes, g.assignmentTarget)
+
+	for _, did := range dids {
+		report.DIDs.Issued++
+		report.DIDs.ByIssuer[cmpOr(did.Issuer, "unknown")]++
+	}
+	return report, nil
+}
+
+func cmpOr(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+func secondsBetween(from, to string) (float64, bool) {
+	start, err := time.Parse(time.RFC3339, from)
+	if err != nil {
+		return 0, false
+	}
+	end, err := time.Parse(time.RFC3339, to)
+	if err != nil || end.Before(start) {
+		return 0, false
+	}
+	return end.Sub(start).Seconds(), true
+}
+
+// summarizeSLA reports the median and 90th percentile by nearest rank
+func summarizeSLA(total int, seconds []float64, target time.Duration) ReportSLA {
+	sla := ReportSLA{Total: total, Acknowledged: len(seconds), Target: target.String()}
+	if len(seconds) == 0 {
+		return sla
+	}
+	sort.Float64s(seconds)
+	for _, s := range seconds {
+		if s <= target.Seconds() {
+			sla.WithinTarget++
+		}
+	}
+	rank := func(p float64) *float64 {
+		value := seconds[int(math.Ceil(p*float64(len(seconds))))-1]
+		return &value
+	}
+	sla.MedianSeconds, sla.P90Seconds = rank(0.5), rank(0.9)
+	return sla
+}
+
+// email sends the report to REPORT_RECIPIENTS with both files attached and returns how many
+// messages were accepted
+func (g *reportGenerator) email(ctx context.Context, generated *GeneratedReport, files map[string][]byte) int {
+	if emailer == nil || len(g.recipients) == 0 {
+		return 0
+	}
+	message, err := emailer.render("operations_report", emailData{Report: generated})
+	if err != nil {
+		logWithContext(ctx, "📊 Failed to render the email for report %s: %v", generated.Anchor.ReportID, err)
+		return 0
+	}
+	name := strings.ReplaceAll(strings.TrimPrefix(generated.Anchor.ReportID, "RPT:"), ":", "-")
+	message.attachments = []emailAttachment{
+		{filename: "report-" + name + ".pdf", contentType: "application/pdf", data: files["pdf"]},
+		{filename: "report-" + name + ".csv", contentType: "text/csv", data: files["csv"]},
+	}
+	sent := 0
+	for _, recipient := range g.recipients {
+		message.to = recipient
+		if err := emailer.mailer.send(ctx, message); err != nil {
+			logWithContext(ctx, "📊 Failed to email report %s to %s: %v", generated.Anchor.ReportID, recipient, err)
+			continue
+		}
+		sent++
+	}
+	return sent
+}
+
+// renderReportPDF lays the report out on A4 pages
+func renderReportPDF(r OperationsReport) []byte {
+	start, _ := time.Parse(time.RFC3339, r.PeriodStart)
+	end, _ := time.Parse(time.RFC3339, r.PeriodEnd)
+	doc := newPDFDocument()
+	doc.Centered("Tourist Safety Operations Report", 16, true)
+	doc.Centered(fmt.Sprintf("%s, %s to %s (%s)", strings.ToUpper(r.Period[:1])+r.Period[1:],
+		start.Format("2 Jan 2006"), end.Add(-time.Second).Format("2 Jan 2006"), r.Timezone), 10, false)
+	doc.Space(8)
+	doc.Field("Report ID", r.ReportID, 10)
+	doc.Field("Generated at", r.GeneratedAt, 10)
+
+	section := func(title string) {
+		doc.Space(6)
+		doc.Rule()
+		doc.Paragraph(title, 12, true)
+	}
+	counts := func(counts map[string]int) {
+		for _, key := range sortedKeys(counts) {
+			doc.Field(key, strconv.Itoa(counts[key]), 10)
+		}
+	}
+	section("Incidents")
+	doc.Field("Total", strconv.Itoa(r.Incidents.Total), 10)
+	doc.Paragraph("By category", 10, true)
+	counts(r.Incidents.ByCategory)
+	doc.Paragraph("By severity", 10, true)
+	counts(r.Incidents.BySeverity)
+	doc.Paragraph("By district", 10, true)
+	counts(r.Incidents.ByDistrict)
+
+	section("Response")
+	sla := func(label string, s ReportSLA) {
+		doc.Paragraph(label, 10, true)
+		doc.Field("Acknowledged", fmt.Sprintf("%d of %d", s.Acknowledged, s.Total), 10)
+		doc.Field("Within "+s.Target, fmt.Sprintf("%d (%s)", s.WithinTarget, percentOf(s.WithinTarget, s.Acknowledged)), 10)
+		doc.Field("Median", formatSeconds(s.MedianSeconds), 10)
+		doc.Field("90th percentile", formatSeconds(s.P90Seconds), 10)
+	}
+	sla("Incident acknowledgement", r.Response.Incidents)
+	sla("Unit acknowledgement", r.Response.Assignments)
+	doc.Field("Escalated", strconv.Itoa(r.Response.Escalated), 10)
+	doc.Field("Declined", strconv.Itoa(r.Response.Declined), 10)
+
+	section("Digital IDs")
+	doc.Field("Issued", strconv.Itoa(r.DIDs.Issued), 10)
+	counts(r.DIDs.ByIssuer)
+	return doc.Bytes()
+}
+
+func percentOf(part, whole int) string {
+	if whole == 0 {
+		return "n/a"
+	}
+	return fmt.Sprintf("%.1f%%", 100*float64(part)/float64(whole))
+}
+
+func formatSeconds(seconds *float64) string {
+	if seconds == nil {
+		return "n/a"
+	}
+	return (time.Duration(*seconds) * time.Second).String()
+}
+
+// renderReportCSV writes one section,metric,value row per figure, so spreadsheets can pivot them
+func renderReportCSV(r OperationsReport) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	rows := [][]string{
+		{"section", "metric", "value"},
+		{"report", "report_id", r.ReportID},
+		{"report", "period", r.Period},
+		{"report", "period_start", r.PeriodStart},
+		{"report", "period_end", r.PeriodEnd},
+		{"report", "generated_at", r.GeneratedAt},
+		{"incidents", "total", strconv.Itoa(r.Incidents.Total)},
+	}
+	counts := func(section string, counts map[string]int) {
+		for _, key := range sortedKeys(counts) {
+			rows = append(rows, []string{section, key, strconv.Itoa(counts[key])})
+		}
+	}
+	counts("incidents_by_category", r.Incidents.ByCategory)
+	counts("incidents_by_severity", r.Incidents.BySeverity)
+	counts("incidents_by_district", r.Incidents.ByDistrict)
+	sla := func(section string, s ReportSLA) {
+		seconds := func(value *float64) string {
+			if value == nil {
+				return ""
+			}
+			return strconv.FormatFloat(*value, 'f', 0, 64)
+		}
+		rows = append(rows,
+			[]string{section, "total", strconv.Itoa(s.Total)},
+			[]string{section, "acknowledged", strconv.Itoa(s.Acknowledged)},
+			[]string{section, "within_target", strconv.Itoa(s.WithinTarget)},
+			[]string{section, "target_seconds", strconv.FormatFloat(mustDuration(s.Target).Seconds(), 'f', 0, 64)},
+			[]string{section, "median_seconds", seconds(s.MedianSeconds)},
+			[]string{section, "p90_seconds", seconds(s.P90Seconds)},
+		)
+	}
+	sla("incident_response", r.Response.Incidents)
+	sla("assignment_response", r.Response.Assignments)
+	rows = append(rows,
+		[]string{"assignment_response", "escalated", strconv.Itoa(r.Response.Escalated)},
+		[]string{"assignment_response", "declined", strconv.Itoa(r.Response.Declined)},
+		[]string{"dids", "issued", strconv.Itoa(r.DIDs.Issued)},
+	)
+	counts("dids_by_issuer", r.DIDs.ByIssuer)
+	if err := w.WriteAll(rows); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func mustDuration(value string) time.Duration {
+	d, _ := time.ParseDuration(value)
+	return d
+}
+
+// ledgerReportSource reads incidents through the incident search, and assignments and DIDs by
+// exporting every document of their type, keeping those created in the period
+type ledgerReportSource struct{}
+
+func (ledgerReportSource) incidents(ctx context.Context, from, to time.Time) ([]IncidentDocument, error) {
+	search := IncidentSearchRequest{From: from.UTC().Format(time.RFC3339), To: to.UTC().Format(time.RFC3339), PageSize: exportPageSize}
+	var incidents []IncidentDocument
+	for {
+		page, err := ledger.SearchIncidents(ctx, search)
+		if err != nil {
+			return nil, err
+		}
+		for _, incident := range page.Incidents {
+			if inPeriod(incident.CreatedAt, from, to) {
+				incidents = append(incidents, incident)
+			}
+		}
+		if page.Bookmark == "" || len(page.Incidents) == 0 {
+			return incidents, nil
+		}
+		search.Bookmark = page.Bookmark
+	}
+}
+
+func (ledgerReportSource) assignments(ctx context.Context, from, to time.Time) ([]AssignmentDocument, error) {
+	return exportedInPeriod(ctx, "assignment", from, to, func(a AssignmentDocument) string { return a.AssignedAt })
+}
+
+func (ledgerReportSource) dids(ctx context.Context, from, to time.Time) ([]DIDDocument, error) {
+	return exportedInPeriod(ctx, "did", from, to, func(d DIDDocument) string { return d.IssuedAt })
+}
+
+func exportedInPeriod[T any](ctx context.Context, docType string, from, to time.Time, at func(T) string) ([]T, error) {
+	var docs []T
+	bookmark := ""
+	for {
+		page, err := ledger.ExportDocuments(ctx, docType, bookmark)
+		if err != nil {
+			return nil, err
+		}
+		for _, raw := range page.Documents {
+			var doc T
+			if err := json.Unmarshal(raw, &doc); err != nil {
+				return nil, &malformedDocumentError{kind: docType, err: err}
+			}
+			if inPeriod(at(doc), from, to) {
+				docs = append(docs, doc)
+			}
+		}
+		if page.Bookmark == "" {
+			return docs, nil
+		}
+		bookmark = page.Bookmark
+	}
+}
+
+func inPeriod(value string, from, to time.Time) bool {
+	t, err := time.Parse(time.RFC3339, value)
+	return err == nil && !t.Before(from) && t.Before(to)
+}
+
+// ReadReportAnchor returns a report's anchored digests
+func (s ledgerService) ReadReportAnchor(ctx context.Context, reportID string) (ReportAnchor, error) {
+	result, err := s.readDocument(ctx, "ReadReportAnchor", reportID)
+	if err != nil {
+		return ReportAnchor{}, err
+	}
+	return decodeDocument[ReportAnchor](result, "report anchor")
+}
+
+// ReportAnchors lists the anchored reports of a period, newest first
+func (ledgerService) ReportAnchors(ctx context.Context, req ReportListRequest) ([]ReportAnchor, error) {
+	if errs := req.Validate(); len(errs) > 0 {
+		return nil, errs
+	}
+	result, err := evaluateTransaction(ctx, "GetReportAnchors", req.Period)
+	if err != nil {
+		return nil, err
+	}
+	anchors, err := decodeDocument[[]ReportAnchor](result, "report anchor")
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(anchors, func(i, j int) bool { return anchors[i].ReportID > anchors[j].ReportID })
+	return anchors, nil
+}
+
+// generateReport generates a report on demand, such as for a period before the schedule was set
+func generateReport(c *gin.Context) {
+	var req GenerateReportRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+	now := time.Now()
+	start, _ := reportPeriodAt(req.Period, now, reports.location)
+	start, _ = reportPeriodAt(req.Period, start.Add(-time.Nanosecond), reports.location)
+	if req.Date != "" {
+		date, _ := time.ParseInLocation(time.DateOnly, req.Date, reports.location)
+		start, _ = reportPeriodAt(req.Period, date, reports.location)
+	}
+	if _, end := reportPeriodAt(req.Period, start, reports.location); end.After(now) {
+		respondValidationErrors(c, ValidationErrors{{Field: "date", Message: "must be in a period that has ended"}})
+		return
+	}
+	setAuditTarget(c, reportID(req.Period, start))
+
+	generated, err := reports.generate(c.Request.Context(), req.Period, start)
+	if err != nil {
+		respondServiceError(c, "Failed to generate report", err)
+		return
+	}
+	reports.markDone(generated.Anchor.ReportID)
+	respondCommitted(c, http.StatusCreated, generated, &TransactionResult{TxID: generated.Anchor.TxID})
+}
+
+func listReports(c *gin.Context) {
+	var req ReportListRequest
+	if !bindQuery(c, &req) {
+		return
+	}
+	anchors, err := ledger.ReportAnchors(c.Request.Context(), req)
+	if err != nil {
+		respondServiceError(c, "Failed to list reports", err)
+		return
+	}
+	respondData(c, http.StatusOK, anchors)
+}
+
+// downloadReport serves a stored report file once it matches the digest anchored on the ledger
+func downloadReport(c *gin.Context) {
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	format := c.Param("format")
+	if !slices.Contains(reportFormats, format) {
+		respondValidationErrors(c, ValidationErrors{{Field: "format", Message: "must be one of " + strings.Join(reportFormats, ", ")}})
+		return
+	}
+	ctx := c.Request.Context()
+	anchor, err := ledger.ReadReportAnchor(ctx, id)
+	if err != nil {
+		respondServiceError(c, "Failed to read report", err)
+		return
+	}
+
+	key := reportStorageKey(anchor, format)
+	body, err := evidenceStore.Get(ctx, key)
+	if errors.Is(err, errObjectNotFound) {
+		respondError(c, http.StatusNotFound, errCodeNotFound, fmt.Sprintf("No stored %s for report %s", format, id))
+		return
+	}
+	if err != nil {
+		logWithContext(ctx, "Failed to read report file %s: %v", key, err)
+		respondError(c, http.StatusServiceUnavailable, errCodeUnavailable, "Failed to read report file from storage")
+		return
+	}
+	defer body.Close()
+	data, err := io.ReadAll(body)
+	if err != nil {
+		respondError(c, http.StatusServiceUnavailable, errCodeUnavailable, "Failed to read report file from storage")
+		return
+	}
+	sum := sha256.Sum256(data)
+	anchored := map[string]string{"pdf": anchor.PDFHash, "csv": anchor.CSVHash}[format]
+	if hex.EncodeToString(sum[:]) != anchored {
+		logWithContext(ctx, "⚠️  Report %s %s hash mismatch: ledger=%s stored=%x", id, format, anchored, sum)
+		respondError(c, http.StatusConflict, errCodeReportTampered, "Stored report file does not match the hash anchored on the ledger")
+		return
+	}
+
+	contentType := map[string]string{"pdf": "application/pdf", "csv": "text/csv; charset=utf-8"}[format]
+	name := strings.ReplaceAll(strings.TrimPrefix(id, "RPT:"), ":", "-")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="report-%s.%s"`, name, format))
+	c.Header("X-Document-Hash", anchored)
+	c.Header("X-Transaction-ID", anchor.TxID)
+	c.Data(http.StatusOK, contentType, data)
+}
@@ -0,0 +1,156 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"testing"
+	"time"
+)
+
+type fakeReportSource struct {
+	incidentDocs   []IncidentDocument
+	assignmentDocs []AssignmentDocument
+	didDocs        []DIDDocument
+}
+
+func (s fakeReportSource) incidents(context.Context, time.Time, time.Time) ([]IncidentDocument, error) {
+	return s.incidentDocs, nil
+}
+
+func (s fakeReportSource) assignments(context.Context, time.Time, time.Time) ([]AssignmentDocument, error) {
+	return s.assignmentDocs, nil
+}
+
+func (s fakeReportSource) dids(context.Context, time.Time, time.Time) ([]DIDDocument, error) {
+	return s.didDocs, nil
+}
+
+func TestReportPeriods(t *testing.T) {
+	ist, err := time.LoadLocation("Asia/Kolkata")
+	if err != nil {
+		t.Skip("timezone data not available")
+	}
+	// 2026-07-08 is a Wednesday; 20:00 UTC is already Thursday in India
+	at := time.Date(2026, 7, 8, 20, 0, 0, 0, time.UTC)
+	start, end := reportPeriodAt(reportDaily, at, ist)
+	if want := time.Date(2026, 7, 9, 0, 0, 0, 0, ist); !start.Equal(want) || !end.Equal(want.AddDate(0, 0, 1)) {
+		t.Errorf("expected the Indian day of 9 July, got %s to %s", start, end)
+	}
+	start, end = reportPeriodAt(reportWeekly, at, ist)
+	if want := time.Date(2026, 7, 6, 0, 0, 0, 0, ist); !start.Equal(want) || !end.Equal(want.AddDate(0, 0, 7)) {
+		t.Errorf("expected the week from Monday 6 July, got %s to %s", start, end)
+	}
+	if start, _ = reportPeriodAt(reportWeekly, time.Date(2026, 7, 12, 23, 0, 0, 0, ist), ist); start.Day() != 6 {
+		t.Errorf("expected Sunday in the week from Monday, got %s", start)
+	}
+	if id := reportID(reportWeekly, start); id != "RPT:weekly:2026-07-06" {
+		t.Errorf("unexpected report ID %s", id)
+	}
+	if key := reportStorageKey(ReportAnchor{ReportID: "RPT:weekly:2026-07-06", Period: reportWeekly}, "csv"); key != "reports/weekly/2026-07-06.csv" {
+		t.Errorf("unexpected storage key %s", key)
+	}
+}
+
+func TestBuildReport(t *testing.T) {
+	g := &reportGenerator{
+		source: fakeReportSource{
+			incidentDocs: []IncidentDocument{
+				{Category: "theft", Severity: "high", CreatedAt: "2026-07-06T10:00:00Z", AcknowledgedAt: "2026-07-06T10:05:00Z"},
+				{Category: "theft", Severity: "low", CreatedAt: "2026-07-06T11:00:00Z", AcknowledgedAt: "2026-07-06T11:30:00Z"},
+				{Category: "medical", Severity: "high", CreatedAt: "2026-07-06T12:00:00Z"},
+			},
+			assignmentDocs: []AssignmentDocument{
+				{Status: "acknowledged", AssignedAt: "2026-07-06T10:00:00Z", AcknowledgedAt: "2026-07-06T10:01:00Z"},
+				{Status: "escalated", AssignedAt: "2026-07-06T11:00:00Z"},
+				{Status: "declined", AssignedAt: "2026-07-06T11:02:00Z"},
+			},
+			didDocs: []DIDDocument{{Issuer: "kyc"}, {Issuer: "kyc"}, {}},
+		},
+		location:         time.UTC,
+		incidentTarget:   15 * time.Minute,
+		assignmentTarget: 2 * time.Minute,
+	}
+	start := time.Date(2026, 7, 6, 0, 0, 0, 0, time.UTC)
+	report, err := g.build(context.Background(), reportDaily, start, start.AddDate(0, 0, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.ReportID != "RPT:daily:2026-07-06" || report.Incidents.Total != 3 || report.Incidents.ByCategory["theft"] != 2 || report.Incidents.BySeverity["high"] != 2 {
+		t.Errorf("unexpected incident counts %+v", report.Incidents)
+	}
+	sla := report.Response.Incidents
+	if sla.Total != 3 || sla.Acknowledged != 2 || sla.WithinTarget != 1 || *sla.MedianSeconds != 300 || *sla.P90Seconds != 1800 {
+		t.Errorf("unexpected incident SLA %+v", sla)
+	}
+	if a := report.Response; a.Assignments.Acknowledged != 1 || a.Assignments.WithinTarget != 1 || a.Escalated != 1 || a.Declined != 1 {
+		t.Errorf("unexpected assignment response %+v", a)
+	}
+	if report.DIDs.Issued != 3 || report.DIDs.ByIssuer["kyc"] != 2 || report.DIDs.ByIssuer["unknown"] != 1 {
+		t.Errorf("unexpected DID counts %+v", report.DIDs)
+	}
+	if empty := summarizeSLA(4, nil, time.Minute); empty.MedianSeconds != nil || empty.Total != 4 {
+		t.Errorf("expected no times without acknowledgements, got %+v", empty)
+	}
+
+	data, err := renderReportCSV(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := map[string]string{}
+	for _, row := range rows[1:] {
+		values[row[0]+"/"+row[1]] = row[2]
+	}
+	for key, want := range map[string]string{
+		"incidents_by_category/theft":             "2",
+		"incident_response/median_seconds":        "300",
+		"incident_response/target_seconds":        "900",
+		"assignment_response/declined":            "1",
+		"dids_by_issuer/kyc":                      "2",
+		"incidents_by_district/" + districtOf(""): "3",
+	} {
+		if values[key] != want {
+			t.Errorf("expected %s to be %s, got %q", key, want, values[key])
+		}
+	}
+	if pdf := renderReportPDF(report); !bytes.HasPrefix(pdf, []byte("%PDF-")) {
+		t.Error("expected a PDF")
+	}
+}
+
+func TestEmailReport(t *testing.T) {
+	templates, err := loadEmailTemplates("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mailer := &recordingMailer{}
+	emailer = &emailNotifier{mailer: mailer, templates: templates}
+	t.Cleanup(func() { emailer = nil })
+
+	g := &reportGenerator{recipients: []string{"ops@example.com", "dgp@example.com"}}
+	generated := &GeneratedReport{
+		Report: OperationsReport{ReportID: "RPT:daily:2026-07-06", Period: reportDaily},
+		Anchor: ReportAnchor{ReportID: "RPT:daily:2026-07-06", TxID: "tx1", PDFHash: "abc"},
+	}
+	if sent := g.email(context.Background(), generated, map[string][]byte{"pdf": []byte("%PDF-"), "csv": []byte("a,b")}); sent != 2 {
+		t.Fatalf("expected two emails, sent %d", sent)
+	}
+	message := mailer.sent[1]
+	if message.to != "dgp@example.com" || message.subject != "Tourist safety daily report RPT:daily:2026-07-06" {
+		t.Errorf("unexpected message %s: %q", message.to, message.subject)
+	}
+	if len(message.attachments) != 2 || message.attachments[0].filename != "report-daily-2026-07-06.pdf" {
+		t.Errorf("expected the PDF and CSV attached, got %+v", message.attachments)
+	}
+
+	formatted := (&smtpMailer{host: "smtp.example.com", from: "sih@example.com"}).format(message)
+	for _, want := range []string{"multipart/mixed", "filename=report-daily-2026-07-06.csv", "JVBERi0="} {
+		if !bytes.Contains(formatted, []byte(want)) {
+			t.Errorf("expected %q in the formatted message", want)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Error codes returned in the response envelope
+const (
+	errCodeValidation = "VALIDATION_FAILED"
+	errCodeNotFound   = "NOT_FOUND"
+	errCodeInternal   = "INTERNAL_ERROR"
+)
+
+// APIResponse is the envelope returned by every /api/v1 endpoint
+type APIResponse struct {
+	Success     bool        `json:"success"`
+	Data        interface{} `json:"data,omitempty"`
+	TxID        string      `json:"tx_id,omitempty"`
+	BlockNumber *uint64     `json:"block_number,omitempty"`
+	Timestamp   string      `json:"timestamp"`
+	Error       *APIError   `json:"error,omitempty"`
+}
+
+// APIError describes why a request failed
+type APIError struct {
+	Code    string           `json:"code"`
+	Message string           `json:"message"`
+	Fields  ValidationErrors `json:"fields,omitempty"`
+}
+
+// TransactionResult captures the outcome of a committed transaction
+type TransactionResult struct {
+	Payload     []byte
+	TxID        string
+	BlockNumber uint64
+}
+
+// respondData writes a successful envelope for a read-only request
+func respondData(c *gin.Context, status int, data interface{}) {
+	c.JSON(status, APIResponse{
+		Success:   true,
+		Data:      data,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+// respondTagged writes a read-only envelope with an ETag derived from the ledger transactions that
+// produced data, or a 304 when If-None-Match already names that version
+func respondTagged(c *gin.Context, data interface{}, txIDs ...string) {
+	etag := ledgerETag(txIDs...)
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", "private, no-cache")
+	if etagMatches(c.GetHeader("If-None-Match"), etag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	respondData(c, http.StatusOK, data)
+}
+
+// ledgerETag names a version of a resource by the transactions that wrote it. The tag is weak since
+// the envelope timestamp changes between otherwise identical responses.
+func ledgerETag(txIDs ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(txIDs, "\n")))
+	return `W/"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches applies the weak comparison If-None-Match calls for
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// respondCommitted writes a successful envelope carrying the transaction ID and commit block
+func respondCommitted(c *gin.Context, status int, data interface{}, result *TransactionResult) {
+	response := APIResponse{
+		Success:   true,
+		Data:      data,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+	}
+	if result != nil {
+		blockNumber := result.BlockNumber
+		response.TxID = result.TxID
+		response.BlockNumber = &blockNumber
+	}
+	c.JSON(status, response)
+}
+
+// respondError writes a failed envelope with the given error code
+func respondError(c *gin.Context, status int, code, message string) {
+	c.JSON(status, APIResponse{
+		Success:   false,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Error:     &APIError{Code: code, Message: localize(c, message)},
+	})
+}
+
+// respondValidationErrors writes a 400 envelope listing every rejected field
+func respondValidationErrors(c *gin.Context, errs ValidationErrors) {
+	c.JSON(http.StatusBadRequest, APIResponse{
+		Success:   false,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Error: &APIError{
+			Code:    errCodeValidation,
+			Message: localize(c, "request validation failed"),
+			Fields:  localizeFields(c, errs),
+		},
+	})
+}
@@ -0,0 +1,410 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	maxUnitShifts       = 14
+	maxUnitCapabilities = 20
+	defaultNearestUnits = 5
+	maxNearestUnits     = 50
+
+	// unitOffShift is the status of an available unit outside its shifts. It is reported, never set.
+	unitOffShift = "off_shift"
+)
+
+var weekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// UnitShift is a weekly shift between two times of day ("15:04") in ROSTER_TIMEZONE. A shift that
+// ends earlier than it starts runs past midnight and belongs to the day it starts on. Days left
+// empty means every day.
+type UnitShift struct {
+	Days  []string `json:"days,omitempty"`
+	Start string   `json:"start"`
+	End   string   `json:"end"`
+}
+
+// activeAt reports whether t, already in the roster timezone, falls within the shift
+func (s UnitShift) activeAt(t time.Time) bool {
+	start, errStart := time.Parse("15:04", s.Start)
+	end, errEnd := time.Parse("15:04", s.End)
+	if errStart != nil || errEnd != nil {
+		return false
+	}
+	from, to := start.Hour()*60+start.Minute(), end.Hour()*60+end.Minute()
+	minute := t.Hour()*60 + t.Minute()
+	on := func(day time.Weekday) bool {
+		return len(s.Days) == 0 || slices.Contains(s.Days, weekdays[day])
+	}
+	switch {
+	case from == to:
+		return on(t.Weekday())
+	case from < to:
+		return on(t.Weekday()) && minute >= from && minute < to
+	default:
+		return (on(t.Weekday()) && minute >= from) || (on((t.Weekday()+6)%7) && minute < to)
+	}
+}
+
+// onShift reports whether any shift covers t. A unit without shifts is always on shift.
+func onShift(shifts []UnitShift, t time.Time, location *time.Location) bool {
+	if len(shifts) == 0 {
+		return true
+	}
+	local := t.In(location)
+	for _, shift := range shifts {
+		if shift.activeAt(local) {
+			return true
+		}
+	}
+	return false
+}
+
+// UnitProfile is a unit's shifts and capabilities as set through the roster API. It replaces the
+// ones in POLICE_UNITS_FILE.
+type UnitProfile struct {
+	UnitID       string      `json:"unit_id"`
+	Shifts       []UnitShift `json:"shifts"`
+	Capabilities []string    `json:"capabilities"`
+	UpdatedAt    string      `json:"updated_at"`
+}
+
+// UnitPosition is where a mobile unit reported itself, as of its fix's recording time
+type UnitPosition struct {
+	Latitude   float64  `json:"latitude"`
+	Longitude  float64  `json:"longitude"`
+	Accuracy   float64  `json:"accuracy,omitempty"`
+	Speed      *float64 `json:"speed,omitempty"`
+	Heading    *float64 `json:"heading,omitempty"`
+	ReportedAt string   `json:"reported_at"`
+}
+
+// UnitRosterRequest replaces a unit's shifts and capabilities
+type UnitRosterRequest struct {
+	Shifts       []UnitShift `json:"shifts"`
+	Capabilities []string    `json:"capabilities"`
+}
+
+func (r UnitRosterRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	if len(r.Shifts) > maxUnitShifts {
+		v.add("shifts", "must have at most %d entries", maxUnitShifts)
+	}
+	for i, shift := range r.Shifts {
+		field := func(name string) string { return fmt.Sprintf("shifts[%d].%s", i, name) }
+		for j, day := range shift.Days {
+			v.oneOf(fmt.Sprintf("%s[%d]", field("days"), j), day, weekdays)
+		}
+		if _, err := time.Parse("15:04", shift.Start); err != nil {
+			v.add(field("start"), "must be a time of day like 08:00")
+		}
+		if _, err := time.Parse("15:04", shift.End); err != nil {
+			v.add(field("end"), "must be a time of day like 20:00")
+		}
+	}
+	if len(r.Capabilities) > maxUnitCapabilities {
+		v.add("capabilities", "must have at most %d entries", maxUnitCapabilities)
+	}
+	for i, capability := range r.Capabilities {
+		v.identifier(fmt.Sprintf("capabilities[%d]", i), capability)
+	}
+	return v.errors
+}
+
+// UnitPositionRequest is a mobile unit reporting where it is
+type UnitPositionRequest struct {
+	Latitude  *float64 `json:"latitude" binding:"required"`
+	Longitude *float64 `json:"longitude" binding:"required"`
+	Accuracy  float64  `json:"accuracy"`
+}
+
+func (r UnitPositionRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	if r.Latitude != nil && (*r.Latitude < -90 || *r.Latitude > 90) {
+		v.add("latitude", "must be between -90 and 90")
+	}
+	if r.Longitude != nil && (*r.Longitude < -180 || *r.Longitude > 180) {
+		v.add("longitude", "must be between -180 and 180")
+	}
+	if r.Accuracy < 0 {
+		v.add("accuracy", "must not be negative")
+	}
+	return v.errors
+}
+
+// NearestUnitsRequest asks for the available units closest to a point. Capabilities is a comma
+// separated list the units must all have.
+type NearestUnitsRequest struct {
+	Kind         string   `form:"kind"`
+	Latitude     *float64 `form:"latitude" binding:"required"`
+	Longitude    *float64 `form:"longitude" binding:"required"`
+	Capabilities string   `form:"capabilities"`
+	Limit        int      `form:"limit"`
+}
+
+func (r NearestUnitsRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	if r.Kind != "" {
+		v.oneOf("kind", r.Kind, unitKinds)
+	}
+	if r.Latitude != nil && (*r.Latitude < -90 || *r.Latitude > 90) {
+		v.add("latitude", "must be between -90 and 90")
+	}
+	if r.Longitude != nil && (*r.Longitude < -180 || *r.Longitude > 180) {
+		v.add("longitude", "must be between -180 and 180")
+	}
+	for _, capability := range splitList(r.Capabilities) {
+		v.identifier("capabilities", capability)
+	}
+	if r.Limit < 0 || r.Limit > maxNearestUnits {
+		v.add("limit", "must be between 1 and %d", maxNearestUnits)
+	}
+	return v.errors
+}
+
+// NearbyUnit is an available unit and how far it is from the point asked about
+type NearbyUnit struct {
+	Unit       DispatchUnit `json:"unit"`
+	DistanceKm float64      `json:"distance_km"`
+}
+
+// unitQuery selects the available units dispatch may send: of the kind, with every capability and
+// not already tried
+type unitQuery struct {
+	kind         string
+	lat, lng     float64
+	capabilities []string
+	exclude      []string
+}
+
+// units lists every unit as dispatch sees it at now: the roster status, off_shift for an available
+// unit outside its shifts, the profile's shifts and capabilities over the file's, and the last
+// position if it is recent enough
+func (d *dispatchService) units(ctx context.Context, now time.Time) ([]DispatchUnit, error) {
+	statuses, err := d.store.roster(ctx)
+	if err != nil {
+		return nil, err
+	}
+	profiles, err := d.store.profiles(ctx)
+	if err != nil {
+		return nil, err
+	}
+	positions, err := d.store.positions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	units := make([]DispatchUnit, 0, len(policeUnits))
+	for _, unit := range policeUnits {
+		if profile, ok := profiles[unit.ID]; ok {
+			unit.Shifts, unit.Capabilities = profile.Shifts, profile.Capabilities
+		}
+		u := DispatchUnit{PoliceUnit: unit, Kind: unit.kind(), Status: statuses[unit.ID], OnShift: onShift(unit.Shifts, now, d.rosterLocation)}
+		if u.Status == "" {
+			u.Status = "available"
+		}
+		if u.Status == "available" && !u.OnShift {
+			u.Status = unitOffShift
+		}
+		if position, ok := positions[unit.ID]; ok {
+			if reported, err := time.Parse(time.RFC3339, position.ReportedAt); err == nil && now.Sub(reported) <= d.positionMaxAge {
+				u.Position = &position
+			}
+		}
+		units = append(units, u)
+	}
+	return units, nil
+}
+
+func (d *dispatchService) unit(ctx context.Context, unitID string) (*DispatchUnit, error) {
+	units, err := d.units(ctx, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	for i := range units {
+		if units[i].ID == unitID {
+			return &units[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// nearest returns the available units the query selects within SOS_MAX_UNIT_DISTANCE_KM, closest
+// first. Distance is from a unit's live position while it is fresh, otherwise from its station.
+func (d *dispatchService) nearest(ctx context.Context, q unitQuery, now time.Time) ([]NearbyUnit, error) {
+	units, err := d.units(ctx, now)
+	if err != nil {
+		return nil, err
+	}
+	var nearby []NearbyUnit
+	for _, unit := range units {
+		if unit.Status != "available" || (q.kind != "" && unit.Kind != q.kind) || slices.Contains(q.exclude, unit.ID) {
+			continue
+		}
+		if !hasCapabilities(unit.Capabilities, q.capabilities) {
+			continue
+		}
+		lat, lng := unit.Latitude, unit.Longitude
+		if unit.Position != nil {
+			lat, lng = unit.Position.Latitude, unit.Position.Longitude
+		}
+		if km := haversineKm(q.lat, q.lng, lat, lng); km <= d.maxKm {
+			nearby = append(nearby, NearbyUnit{Unit: unit, DistanceKm: km})
+		}
+	}
+	sort.Slice(nearby, func(i, j int) bool {
+		if nearby[i].DistanceKm != nearby[j].DistanceKm {
+			return nearby[i].DistanceKm < nearby[j].DistanceKm
+		}
+		return nearby[i].Unit.ID < nearby[j].Unit.ID
+	})
+	return nearby, nil
+}
+
+// nearestAvailable returns the closest unit the query selects, or nil when there is none
+func (d *dispatchService) nearestAvailable(ctx context.Context, q unitQuery) (*PoliceUnit, float64, error) {
+	nearby, err := d.nearest(ctx, q, time.Now())
+	if err != nil || len(nearby) == 0 {
+		return nil, 0, err
+	}
+	return policeUnitByID(nearby[0].Unit.ID), nearby[0].DistanceKm, nil
+}
+
+func hasCapabilities(have, want []string) bool {
+	for _, capability := range want {
+		if !slices.Contains(have, capability) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseRequiredCapabilities reads DISPATCH_REQUIRED_CAPABILITIES, a list of
+// category/kind=capability pairs joined by "+" where a unit needs several
+func parseRequiredCapabilities(value string) (map[string][]string, error) {
+	required := map[string][]string{}
+	for _, item := range splitList(value) {
+		key, capabilities, _ := strings.Cut(item, "=")
+		category, kind, _ := strings.Cut(strings.TrimSpace(key), "/")
+		if !slices.Contains(incidentCategories, category) || !slices.Contains(unitKinds, kind) {
+			return nil, fmt.Errorf("has unknown category or unit kind in %q", item)
+		}
+		for _, capability := range strings.Split(capabilities, "+") {
+			if capability = strings.TrimSpace(capability); capability == "" {
+				return nil, fmt.Errorf("has an empty capability in %q", item)
+			}
+			required[category+"/"+kind] = append(required[category+"/"+kind], capability)
+		}
+	}
+	return required, nil
+}
+
+func setUnitRoster(c *gin.Context) {
+	if !requireDispatch(c) {
+		return
+	}
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	var req UnitRosterRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+	if policeUnitByID(id) == nil {
+		respondError(c, http.StatusNotFound, errCodeNotFound, "Unit not found")
+		return
+	}
+	setAuditTarget(c, id)
+	ctx := c.Request.Context()
+	profile := UnitProfile{
+		UnitID:       id,
+		Shifts:       req.Shifts,
+		Capabilities: req.Capabilities,
+		UpdatedAt:    time.Now().UTC().Format(time.RFC3339),
+	}
+	if err := dispatcher.store.setProfile(ctx, profile); err != nil {
+		respondServiceError(c, "Failed to update unit roster", err)
+		return
+	}
+	respondUnit(c, id)
+}
+
+func reportUnitPosition(c *gin.Context) {
+	if !requireDispatch(c) {
+		return
+	}
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	var req UnitPositionRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+	if policeUnitByID(id) == nil {
+		respondError(c, http.StatusNotFound, errCodeNotFound, "Unit not found")
+		return
+	}
+	setAuditTarget(c, id)
+	now := time.Now()
+	fix := UnitFix{Latitude: req.Latitude, Longitude: req.Longitude, Accuracy: req.Accuracy, RecordedAt: now.UTC().Format(time.RFC3339)}
+	if _, err := dispatcher.recordFixes(c.Request.Context(), id, []UnitFix{fix}, now); err != nil {
+		respondServiceError(c, "Failed to record unit position", err)
+		return
+	}
+	respondUnit(c, id)
+}
+
+// respondUnit answers with the unit as dispatch now sees it
+func respondUnit(c *gin.Context, unitID string) {
+	unit, err := dispatcher.unit(c.Request.Context(), unitID)
+	if err != nil {
+		respondServiceError(c, "Failed to read the unit roster", err)
+		return
+	}
+	respondData(c, http.StatusOK, unit)
+}
+
+func listNearestUnits(c *gin.Context) {
+	if !requireDispatch(c) {
+		return
+	}
+	var req NearestUnitsRequest
+	if !bindQuery(c, &req) {
+		return
+	}
+	if req.Limit == 0 {
+		req.Limit = defaultNearestUnits
+	}
+	nearby, err := dispatcher.nearest(c.Request.Context(), unitQuery{
+		kind:         req.Kind,
+		lat:          *req.Latitude,
+		lng:          *req.Longitude,
+		capabilities: splitList(req.Capabilities),
+	}, time.Now())
+	if err != nil {
+		respondServiceError(c, "Failed to read the unit roster", err)
+		return
+	}
+	nearby = nearby[:min(len(nearby), req.Limit)]
+	for i := range nearby {
+		nearby[i].DistanceKm = math.Round(nearby[i].DistanceKm*100) / 100
+	}
+	respondData(c, http.StatusOK, append([]NearbyUnit{}, nearby...))
+}
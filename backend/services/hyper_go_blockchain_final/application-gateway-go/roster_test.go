@@ -0,0 +1,114 @@
+package gateway
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestUnitShifts(t *testing.T) {
+	ist, err := time.LoadLocation("Asia/Kolkata")
+	if err != nil {
+		t.Skip("timezone data not available")
+	}
+	// 2026-07-06 is a Monday
+	at := func(day int, clock string) time.Time {
+		parsed, _ := time.Parse("15:04", clock)
+		return time.Date(2026, 7, day, parsed.Hour(), parsed.Minute(), 0, 0, ist)
+	}
+	day := []UnitShift{{Days: []string{"mon", "tue"}, Start: "08:00", End: "20:00"}}
+	night := []UnitShift{{Days: []string{"mon"}, Start: "22:00", End: "06:00"}}
+
+	for name, tt := range map[string]struct {
+		shifts []UnitShift
+		at     time.Time
+		want   bool
+	}{
+		"no shifts":          {nil, at(5, "03:00"), true},
+		"day shift":          {day, at(6, "08:00"), true},
+		"day shift ended":    {day, at(6, "20:00"), false},
+		"day off":            {day, at(8, "12:00"), false},
+		"night shift":        {night, at(6, "23:30"), true},
+		"past midnight":      {night, at(7, "05:59"), true},
+		"before the night":   {night, at(6, "05:00"), false},
+		"night shift ended":  {night, at(7, "06:00"), false},
+		"every day all day":  {[]UnitShift{{Start: "00:00", End: "00:00"}}, at(9, "13:00"), true},
+		"other timezone UTC": {day, time.Date(2026, 7, 6, 14, 45, 0, 0, time.UTC), false},
+	} {
+		if got := onShift(tt.shifts, tt.at, ist); got != tt.want {
+			t.Errorf("%s: expected %v, got %v", name, tt.want, got)
+		}
+	}
+
+	bad := UnitRosterRequest{Shifts: []UnitShift{{Days: []string{"monday"}, Start: "8am", End: "20:00"}}, Capabilities: []string{"first aid"}}
+	fields := map[string]bool{}
+	for _, err := range bad.Validate() {
+		fields[err.Field] = true
+	}
+	if !fields["shifts[0].days[0]"] || !fields["shifts[0].start"] || !fields["capabilities[0]"] {
+		t.Errorf("expected the day, start and capability rejected, got %v", fields)
+	}
+}
+
+func TestRosterNearest(t *testing.T) {
+	previous := policeUnits
+	policeUnits = []PoliceUnit{
+		{ID: "guwahati", Latitude: 26.1445, Longitude: 91.7362},
+		{ID: "dispur", Latitude: 26.1433, Longitude: 91.7898, Capabilities: []string{"search_rescue"}},
+		{ID: "patrol-1", Latitude: 25.5788, Longitude: 91.8933},
+	}
+	defer func() { policeUnits = previous }()
+	ctx := context.Background()
+	d := &dispatchService{store: newMemoryDispatchStore(), maxKm: 50, rosterLocation: time.UTC, positionMaxAge: 10 * time.Minute}
+	now := time.Now().UTC()
+
+	// Capabilities from the file are required, and a profile replaces them
+	q := unitQuery{kind: unitPolice, lat: 26.10, lng: 91.70, capabilities: []string{"search_rescue"}}
+	if unit, _, _ := d.nearestAvailable(ctx, q); unit == nil || unit.ID != "dispur" {
+		t.Fatalf("expected Dispur for search and rescue, got %+v", unit)
+	}
+	d.store.setProfile(ctx, UnitProfile{UnitID: "dispur", Capabilities: []string{"first_aid"}})
+	if unit, _, _ := d.nearestAvailable(ctx, q); unit != nil {
+		t.Fatalf("expected no unit once Dispur's profile dropped the capability, got %s", unit.ID)
+	}
+
+	// A unit outside its shifts is off shift and passed over
+	closed := now.Add(time.Hour).Format("15:04")
+	d.store.setProfile(ctx, UnitProfile{UnitID: "guwahati", Shifts: []UnitShift{{Start: closed, End: now.Add(2 * time.Hour).Format("15:04")}}})
+	units, _ := d.units(ctx, now)
+	if units[0].Status != unitOffShift || units[0].OnShift {
+		t.Errorf("expected Guwahati off shift, got %+v", units[0])
+	}
+	nearby, _ := d.nearest(ctx, unitQuery{kind: unitPolice, lat: 26.10, lng: 91.70}, now)
+	if len(nearby) != 1 || nearby[0].Unit.ID != "dispur" {
+		t.Fatalf("expected only Dispur within 50km, got %+v", nearby)
+	}
+
+	// A fresh position moves the patrol within range; a stale one does not
+	d.store.setPosition(ctx, "patrol-1", UnitPosition{Latitude: 26.11, Longitude: 91.71, ReportedAt: now.Add(-time.Minute).Format(time.RFC3339)})
+	nearby, _ = d.nearest(ctx, unitQuery{kind: unitPolice, lat: 26.10, lng: 91.70}, now)
+	if len(nearby) != 2 || nearby[0].Unit.ID != "patrol-1" || nearby[0].Unit.Position == nil || nearby[0].DistanceKm > 2 {
+		t.Fatalf("expected the patrol first from its live position, got %+v", nearby)
+	}
+	if nearby, _ = d.nearest(ctx, unitQuery{kind: unitPolice, lat: 26.10, lng: 91.70}, now.Add(30*time.Minute)); len(nearby) != 1 || nearby[0].Unit.ID != "dispur" {
+		t.Errorf("expected the patrol back at its station once the position is stale, got %+v", nearby)
+	}
+}
+
+func TestParseRequiredCapabilities(t *testing.T) {
+	required, err := parseRequiredCapabilities("missing_person/police=search_rescue+drone, accident/medical=trauma")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := required["missing_person/police"]; len(got) != 2 || got[1] != "drone" {
+		t.Errorf("expected two capabilities for missing persons, got %v", got)
+	}
+	if got := required["accident/medical"]; len(got) != 1 || got[0] != "trauma" {
+		t.Errorf("expected trauma for accidents, got %v", got)
+	}
+	for _, bad := range []string{"lost/police=search_rescue", "accident/fire=ladder", "accident/medical="} {
+		if _, err := parseRequiredCapabilities(bad); err == nil {
+			t.Errorf("expected %q rejected", bad)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import "math"
+
+const (
+	rtreeMaxEntries = 8
+	rtreeMinEntries = 3
+)
+
+// zoneTree is an R-tree of zone bounding boxes (Guttman, quadratic split), so evaluation only
+// tests the zones whose boxes contain the point however large or small they are
+type zoneTree struct {
+	root *rtreeNode
+	size int
+}
+
+type rtreeNode struct {
+	leaf    bool
+	entries []rtreeEntry
+	parent  *rtreeNode
+}
+
+// rtreeEntry is a zone in a leaf, or a child node and the box covering it
+type rtreeEntry struct {
+	box   bounds
+	child *rtreeNode
+	zone  *indexedZone
+}
+
+func newZoneTree() *zoneTree {
+	return &zoneTree{root: &rtreeNode{leaf: true}}
+}
+
+func (b bounds) union(o bounds) bounds {
+	return bounds{math.Min(b.minLng, o.minLng), math.Min(b.minLat, o.minLat), math.Max(b.maxLng, o.maxLng), math.Max(b.maxLat, o.maxLat)}
+}
+
+func (b bounds) area() float64 {
+	return (b.maxLng - b.minLng) * (b.maxLat - b.minLat)
+}
+
+func (b bounds) intersects(o bounds) bool {
+	return b.minLng <= o.maxLng && o.minLng <= b.maxLng && b.minLat <= o.maxLat && o.minLat <= b.maxLat
+}
+
+func (n *rtreeNode) box() bounds {
+	box := n.entries[0].box
+	for _, e := range n.entries[1:] {
+		box = box.union(e.box)
+	}
+	return box
+}
+
+// insert adds a zone, splitting full nodes on the way back up
+func (t *zoneTree) insert(z *indexedZone) {
+	t.insertEntry(rtreeEntry{box: z.bbox, zone: z})
+	t.size++
+}
+
+// insertEntry descends to the leaf whose box grows least and adds the entry there
+func (t *zoneTree) insertEntry(e rtreeEntry) {
+	n := t.root
+	for !n.leaf {
+		best, bestGrowth, bestArea := 0, math.Inf(1), math.Inf(1)
+		for i, candidate := range n.entries {
+			area := candidate.box.area()
+			growth := candidate.box.union(e.box).area() - area
+			if growth < bestGrowth || (growth == bestGrowth && area < bestArea) {
+				best, bestGrowth, bestArea = i, growth, area
+			}
+		}
+		n = n.entries[best].child
+	}
+	n.entries = append(n.entries, e)
+	t.adjust(n)
+}
+
+// adjust splits overfull nodes and refreshes the boxes from n up to the root
+func (t *zoneTree) adjust(n *rtreeNode) {
+	for {
+		var sibling *rtreeNode
+		if len(n.entries) > rtreeMaxEntries {
+			sibling = n.split()
+		}
+		parent := n.parent
+		if parent == nil {
+			if sibling != nil {
+				t.root = &rtreeNode{entries: []rtreeEntry{{box: n.box(), child: n}, {box: sibling.box(), child: sibling}}}
+				n.parent, sibling.parent = t.root, t.root
+			}
+			return
+		}
+		for i := range parent.entries {
+			if parent.entries[i].child == n {
+				parent.entries[i].box = n.box()
+				break
+			}
+		}
+		if sibling != nil {
+			sibling.parent = parent
+			parent.entries = append(parent.entries, rtreeEntry{box: sibling.box(), child: sibling})
+		}
+		n = parent
+	}
+}
+
+// split moves about half of n's entries to a new sibling, seeding each with the pair that would
+// waste the most area together
+func (n *rtreeNode) split() *rtreeNode {
+	entries := n.entries
+	seedA, seedB, worst := 0, 1, math.Inf(-1)
+	for i := range entries {
+		for j := i + 1; j < len(entries); j++ {
+			waste := entries[i].box.union(entries[j].box).area() - entries[i].box.area() - entries[j].box.area()
+			if waste > worst {
+				seedA, seedB, worst = i, j, waste
+			}
+		}
+	}
+
+	a := []rtreeEntry{entries[seedA]}
+	b := []rtreeEntry{entries[seedB]}
+	boxA, boxB := entries[seedA].box, entries[seedB].box
+	remaining := make([]rtreeEntry, 0, len(entries)-2)
+	for i, e := range entries {
+		if i != seedA && i != seedB {
+			remaining = append(remaining, e)
+		}
+	}
+	for len(remaining) > 0 {
+		// Fill whichever group must take the rest to reach the minimum
+		if len(a)+len(remaining) == rtreeMinEntries {
+			a = append(a, remaining...)
+			break
+		}
+		if len(b)+len(remaining) == rtreeMinEntries {
+			b = append(b, remaining...)
+			break
+		}
+		e := remaining[0]
+		remaining = remaining[1:]
+		growA := boxA.union(e.box).area() - boxA.area()
+		growB := boxB.union(e.box).area() - boxB.area()
+		if growA < growB || (growA == growB && len(a) <= len(b)) {
+			a, boxA = append(a, e), boxA.union(e.box)
+		} else {
+			b, boxB = append(b, e), boxB.union(e.box)
+		}
+	}
+
+	n.entries = a
+	sibling := &rtreeNode{leaf: n.leaf, entries: b}
+	if !n.leaf {
+		for _, e := range b {
+			e.child.parent = sibling
+		}
+	}
+	return sibling
+}
+
+// remove deletes a zone, reinserting the entries of nodes left underfull
+func (t *zoneTree) remove(z *indexedZone) bool {
+	leaf := t.findLeaf(t.root, z)
+	if leaf == nil {
+		return false
+	}
+	for i, e := range leaf.entries {
+		if e.zone == z {
+			leaf.entries = append(leaf.entries[:i], leaf.entries[i+1:]...)
+			break
+		}
+	}
+	t.size--
+	t.condense(leaf)
+	return true
+}
+
+func (t *zoneTree) findLeaf(n *rtreeNode, z *indexedZone) *rtreeNode {
+	for _, e := range n.entries {
+		if n.leaf {
+			if e.zone == z {
+				return n
+			}
+			continue
+		}
+		if e.box.intersects(z.bbox) {
+			if found := t.findLeaf(e.child, z); found != nil {
+				return found
+			}
+		}
+	}
+	return nil
+}
+
+// condense drops underfull nodes between n and the root, then reinserts the zones they held
+func (t *zoneTree) condense(n *rtreeNode) {
+	var orphans []*indexedZone
+	for n.parent != nil {
+		parent := n.parent
+		for i, e := range parent.entries {
+			if e.child != n {
+				continue
+			}
+			if len(n.entries) < rtreeMinEntries {
+				parent.entries = append(parent.entries[:i], parent.entries[i+1:]...)
+				orphans = n.zones(orphans)
+			} else {
+				parent.entries[i].box = n.box()
+			}
+			break
+		}
+		n = parent
+	}
+	for !t.root.leaf && len(t.root.entries) == 1 {
+		t.root = t.root.entries[0].child
+		t.root.parent = nil
+	}
+	if !t.root.leaf && len(t.root.entries) == 0 {
+		t.root = &rtreeNode{leaf: true}
+	}
+	for _, z := range orphans {
+		t.insertEntry(rtreeEntry{box: z.bbox, zone: z})
+	}
+}
+
+// zones appends every zone under n
+func (n *rtreeNode) zones(out []*indexedZone) []*indexedZone {
+	for _, e := range n.entries {
+		if n.leaf {
+			out = append(out, e.zone)
+		} else {
+			out = e.child.zones(out)
+		}
+	}
+	return out
+}
+
+// search calls fn for every zone whose box intersects box
+func (t *zoneTree) search(box bounds, fn func(*indexedZone)) {
+	var walk func(n *rtreeNode)
+	walk = func(n *rtreeNode) {
+		for _, e := range n.entries {
+			if !e.box.intersects(box) {
+				continue
+			}
+			if n.leaf {
+				fn(e.zone)
+			} else {
+				walk(e.child)
+			}
+		}
+	}
+	walk(t.root)
+}
@@ -1,4 +1,12 @@
 #!/bin/bash
 go mod tidy
-go build -o sih-app *.go
+go build -o sih-app ./cmd/sih-app
+go build -o sih-indexer ./cmd/sih-indexer
 
+go build -o sih-network ./cmd/sih-network
+go build -o sih-loadtest ./cmd/sih-loadtest
+go build -o sih-cli ./cmd/sih-cli
+go build -o sih-backup ./cmd/sih-backup
+go build -o sih-verify ./cmd/sih-verify
+go build -o sih-synth ./cmd/sih-synth
+go build -o sih-migrate ./cmd/sih-migrate
@@ -0,0 +1,151 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// Presigner is implemented by stores that can hand out time-limited direct download URLs
+type Presigner interface {
+	PresignGet(key string, expiry time.Duration) (string, error)
+}
+
+// s3Store keeps evidence in an S3-compatible bucket (AWS S3, MinIO) through the AWS SDK
+type s3Store struct {
+	client   *s3.Client
+	presign  *s3.PresignClient
+	endpoint string
+	bucket   string
+}
+
+// newS3StoreFromEnv takes credentials from S3_ACCESS_KEY and S3_SECRET_KEY when set, otherwise from
+// the SDK's default chain: the environment, shared config, or the instance or task role
+func newS3StoreFromEnv() (*s3Store, error) {
+	bucket, endpoint := getEnv("S3_BUCKET", ""), getEnv("S3_ENDPOINT", "")
+	accessKey, secretKey := getEnv("S3_ACCESS_KEY", ""), getEnv("S3_SECRET_KEY", "")
+	if bucket == "" {
+		return nil, errors.New("S3_BUCKET must be set")
+	}
+	if (accessKey == "") != (secretKey == "") {
+		return nil, errors.New("S3_ACCESS_KEY and S3_SECRET_KEY must be set together")
+	}
+
+	options := []func(*config.LoadOptions) error{
+		config.WithRegion(getEnv("S3_REGION", "us-east-1")),
+		config.WithHTTPClient(awshttp.NewBuildableClient().WithTimeout(getEnvDuration("S3_TIMEOUT", 5*time.Minute))),
+	}
+	if accessKey != "" {
+		options = append(options, config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(accessKey, secretKey, "")))
+	}
+	cfg, err := config.LoadDefaultConfig(context.Background(), options...)
+	if err != nil {
+		return nil, err
+	}
+	return newS3Store(cfg, endpoint, bucket, getEnvBool("S3_PATH_STYLE", true))
+}
+
+func newS3Store(cfg aws.Config, endpoint, bucket string, pathStyle bool) (*s3Store, error) {
+	host := "s3." + cfg.Region + ".amazonaws.com"
+	if endpoint != "" {
+		u, err := url.Parse(endpoint)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid S3_ENDPOINT %q", endpoint)
+		}
+		host = u.Host
+	}
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+		}
+		o.UsePathStyle = pathStyle
+		// Not every S3-compatible store accepts the checksums the SDK adds by default
+		o.RequestChecksumCalculation = aws.RequestChecksumCalculationWhenRequired
+		o.ResponseChecksumValidation = aws.ResponseChecksumValidationWhenRequired
+	})
+	return &s3Store{client: client, presign: s3.NewPresignClient(client), endpoint: host, bucket: bucket}, nil
+}
+
+// Put uploads an object. The SDK needs a length and a body it can rewind to sign, so other streams
+// are spooled to disk first.
+func (s *s3Store) Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error {
+	if _, seekable := body.(io.ReadSeeker); size < 0 || !seekable {
+		spool, err := os.CreateTemp("", "evidence-spool-*")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(spool.Name())
+		defer spool.Close()
+
+		if size, err = io.Copy(spool, body); err != nil {
+			return err
+		}
+		if _, err := spool.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		body = spool
+	}
+
+	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:        aws.String(s.bucket),
+		Key:           aws.String(key),
+		Body:          body,
+		ContentLength: aws.Int64(size),
+		ContentType:   aws.String(contentType),
+	})
+	if err != nil {
+		return fmt.Errorf("object storage upload failed: %w", err)
+	}
+	return nil
+}
+
+func (s *s3Store) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(s.bucket), Key: aws.String(key)})
+	if err != nil {
+		var noSuchKey *types.NoSuchKey
+		var response *awshttp.ResponseError
+		if errors.As(err, &noSuchKey) || errors.As(err, &response) && response.HTTPStatusCode() == http.StatusNotFound {
+			return nil, errObjectNotFound
+		}
+		return nil, fmt.Errorf("object storage download failed: %w", err)
+	}
+	return out.Body, nil
+}
+
+func (s *s3Store) Delete(ctx context.Context, key string) error {
+	if _, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: aws.String(s.bucket), Key: aws.String(key)}); err != nil {
+		return fmt.Errorf("object storage delete failed: %w", err)
+	}
+	return nil
+}
+
+// PresignGet returns a query-signed GET URL valid for expiry (at most seven days, per SigV4)
+func (s *s3Store) PresignGet(key string, expiry time.Duration) (string, error) {
+	if expiry <= 0 || expiry > 7*24*time.Hour {
+		return "", fmt.Errorf("presign expiry must be between 1s and 7 days")
+	}
+	req, err := s.presign.PresignGetObject(context.Background(), &s3.GetObjectInput{Bucket: aws.String(s.bucket), Key: aws.String(key)},
+		s3.WithPresignExpires(expiry))
+	if err != nil {
+		return "", err
+	}
+	return req.URL, nil
+}
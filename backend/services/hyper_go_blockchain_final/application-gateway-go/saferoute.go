@@ -0,0 +1,459 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	errCodeRoutingDisabled = "ROUTING_DISABLED"
+	errCodeRoutingFailed   = "ROUTING_FAILED"
+	errCodeRouteNotFound   = "ROUTE_NOT_FOUND"
+
+	// maxRouteSamples bounds the points scored along one route, widening the spacing on long routes
+	maxRouteSamples = 500
+)
+
+var routeProfiles = []string{"walking", "cycling", "driving"}
+
+// SafeRouteRequest asks for routes between two positions, scored for departure at DepartAt, or now
+type SafeRouteRequest struct {
+	OriginLatitude       *float64 `form:"origin_latitude"`
+	OriginLongitude      *float64 `form:"origin_longitude"`
+	DestinationLatitude  *float64 `form:"destination_latitude"`
+	DestinationLongitude *float64 `form:"destination_longitude"`
+	DepartAt             string   `form:"depart_at"`
+	Profile              string   `form:"profile"`
+}
+
+func (r SafeRouteRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	for _, c := range []struct {
+		field string
+		value *float64
+		limit float64
+	}{
+		{"origin_latitude", r.OriginLatitude, 90},
+		{"origin_longitude", r.OriginLongitude, 180},
+		{"destination_latitude", r.DestinationLatitude, 90},
+		{"destination_longitude", r.DestinationLongitude, 180},
+	} {
+		switch {
+		case c.value == nil:
+			v.add(c.field, "is required")
+		case *c.value < -c.limit || *c.value > c.limit:
+			v.add(c.field, "must be between %g and %g", -c.limit, c.limit)
+		}
+	}
+	if r.DepartAt != "" {
+		v.rfc3339("depart_at", r.DepartAt)
+	}
+	if r.Profile != "" {
+		v.oneOf("profile", r.Profile, routeProfiles)
+	}
+	return v.errors
+}
+
+// RouteSegment is a stretch of a route at high risk or above. Positions are [longitude, latitude].
+type RouteSegment struct {
+	FromMeters float64    `json:"from_meters"`
+	ToMeters   float64    `json:"to_meters"`
+	Start      [2]float64 `json:"start"`
+	End        [2]float64 `json:"end"`
+	Risk       float64    `json:"risk"`
+	RiskLevel  string     `json:"risk_level"`
+	ZoneID     string     `json:"zone_id,omitempty"`
+	// Incidents counts the incidents and SOS alerts near the riskiest point of the segment
+	Incidents int `json:"incidents"`
+}
+
+// SafeRoute is one route option. Risk is the average risk along the route from 0 to 1; PeakRisk is
+// its riskiest point.
+type SafeRoute struct {
+	Rank            int            `json:"rank"`
+	Recommended     bool           `json:"recommended"`
+	DistanceMeters  float64        `json:"distance_meters"`
+	DurationSeconds float64        `json:"duration_seconds"`
+	DetourSeconds   float64        `json:"detour_seconds"`
+	Risk            float64        `json:"risk"`
+	PeakRisk        float64        `json:"peak_risk"`
+	RiskLevel       string         `json:"risk_level"`
+	Zones           []string       `json:"zones"`
+	Segments        []RouteSegment `json:"segments"`
+	// Geometry is the route as GeoJSON LineString coordinates
+	Geometry [][2]float64 `json:"geometry"`
+
+	// cost ranks the route: its risk plus the detour it costs
+	cost float64
+}
+
+// SafeRoutes lists route options, safest first after the detour they cost. IncidentsAssessed is
+// false without the off-chain index, when only zones rate the routes.
+type SafeRoutes struct {
+	Profile           string      `json:"profile"`
+	DepartAt          string      `json:"depart_at"`
+	IncidentsAssessed bool        `json:"incidents_assessed"`
+	Routes            []SafeRoute `json:"routes"`
+}
+
+// engineRoute is a route as the routing engine returns it
+type engineRoute struct {
+	distance, duration float64
+	// coordinates are [longitude, latitude] positions
+	coordinates [][2]float64
+}
+
+// routingEngine finds alternative routes between two positions
+type routingEngine interface {
+	routes(ctx context.Context, profile string, from, to [2]float64, alternatives int) ([]engineRoute, error)
+}
+
+// errNoRoute is returned when the engine finds no route between the positions
+var errNoRoute = errors.New("no route between these positions")
+
+// routingError is a failure of the routing engine, as opposed to a route that does not exist
+type routingError struct {
+	err error
+}
+
+func (e *routingError) Error() string { return "routing engine: " + e.err.Error() }
+func (e *routingError) Unwrap() error { return e.err }
+
+// osrmRouter asks an OSRM server's route service for alternatives with full GeoJSON geometry
+type osrmRouter struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+func (o *osrmRouter) routes(ctx context.Context, profile string, from, to [2]float64, alternatives int) ([]engineRoute, error) {
+	endpoint := fmt.Sprintf("%s/route/v1/%s/%f,%f;%f,%f?%s", o.baseURL, url.PathEscape(profile), from[0], from[1], to[0], to[1], url.Values{
+		"alternatives": {fmt.Sprint(alternatives)},
+		"overview":     {"full"},
+		"geometries":   {"geojson"},
+	}.Encode())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := o.httpClient.Do(req)
+	if err != nil {
+		return nil, &routingError{err}
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+	if err != nil {
+		return nil, &routingError{err}
+	}
+	var result struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+		Routes  []struct {
+			Distance float64 `json:"distance"`
+			Duration float64 `json:"duration"`
+			Geometry struct {
+				Coordinates [][2]float64 `json:"coordinates"`
+			} `json:"geometry"`
+		} `json:"routes"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, &routingError{fmt.Errorf("responded with %d: %s", resp.StatusCode, bytes.TrimSpace(body[:min(len(body), 4096)]))}
+	}
+	// OSRM answers 400 for positions it cannot route between, with a code saying why
+	switch result.Code {
+	case "Ok":
+	case "NoRoute", "NoSegment":
+		return nil, errNoRoute
+	default:
+		return nil, &routingError{fmt.Errorf("responded with %d %s: %s", resp.StatusCode, result.Code, result.Message)}
+	}
+	routes := make([]engineRoute, 0, len(result.Routes))
+	for _, r := range result.Routes {
+		if len(r.Geometry.Coordinates) < 2 {
+			continue
+		}
+		routes = append(routes, engineRoute{distance: r.Distance, duration: r.Duration, coordinates: r.Geometry.Coordinates})
+	}
+	if len(routes) == 0 {
+		return nil, errNoRoute
+	}
+	return routes, nil
+}
+
+// routeAdvisor scores a routing engine's alternatives by the zones they cross and the incidents
+// recorded near them. Incidents at a similar time of day to the route's count fully and others
+// count offHourWeight, since a lane that is busy by day can be the one to avoid at night.
+type routeAdvisor struct {
+	engine       routingEngine
+	alternatives int
+	sampleMeters float64
+	location     *time.Location
+	levels       []zoneRiskThreshold
+
+	incidentRadiusKm   float64
+	incidentWindow     time.Duration
+	incidentSaturation float64
+	hourWindow         time.Duration
+	offHourWeight      float64
+	// detourWeight is the risk a route must save to be worth taking twice as long as the fastest
+	detourWeight float64
+
+	// located reads the incidents and SOS alerts in a box; nil without the off-chain index
+	located func(ctx context.Context, box bounds, since time.Time) ([]locatedRecord, error)
+}
+
+var safeRoutes *routeAdvisor
+
+// initSafeRoutes runs after initOffchainIndex and initGeofence
+func initSafeRoutes() {
+	base := strings.TrimRight(getEnv("SAFE_ROUTE_OSRM_URL", ""), "/")
+	if base == "" {
+		log.Println("🛣️ SAFE_ROUTE_OSRM_URL not set, safe route recommendations disabled")
+		return
+	}
+	timezone := getEnv("SAFE_ROUTE_TIMEZONE", defaultZoneTimezone)
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		panic(fmt.Errorf("unknown SAFE_ROUTE_TIMEZONE %q", timezone))
+	}
+	levels, err := parseZoneRiskThresholds(getEnv("SAFE_ROUTE_LEVELS", "medium=0.3,high=0.6"))
+	if err != nil {
+		panic(fmt.Errorf("invalid SAFE_ROUTE_LEVELS: %w", err))
+	}
+	a := &routeAdvisor{
+		engine:             &osrmRouter{baseURL: base, httpClient: &http.Client{Timeout: getEnvDuration("SAFE_ROUTE_TIMEOUT", 10*time.Second)}},
+		alternatives:       getEnvInt("SAFE_ROUTE_ALTERNATIVES", 3),
+		sampleMeters:       getEnvFloat("SAFE_ROUTE_SAMPLE_METERS", 100),
+		location:           location,
+		levels:             levels,
+		incidentRadiusKm:   getEnvFloat("SAFE_ROUTE_INCIDENT_RADIUS_KM", 0.3),
+		incidentWindow:     getEnvDuration("SAFE_ROUTE_INCIDENT_WINDOW", 90*24*time.Hour),
+		incidentSaturation: getEnvFloat("SAFE_ROUTE_INCIDENT_SATURATION", 2),
+		hourWindow:         getEnvDuration("SAFE_ROUTE_HOUR_WINDOW", 2*time.Hour),
+		offHourWeight:      getEnvFloat("SAFE_ROUTE_OFF_HOUR_WEIGHT", 0.5),
+		detourWeight:       getEnvFloat("SAFE_ROUTE_DETOUR_WEIGHT", 0.5),
+	}
+	if a.alternatives < 1 || a.sampleMeters <= 0 || a.incidentRadiusKm <= 0 || a.incidentWindow <= 0 || a.incidentSaturation <= 0 {
+		panic(errors.New("SAFE_ROUTE_ALTERNATIVES, SAFE_ROUTE_SAMPLE_METERS, SAFE_ROUTE_INCIDENT_RADIUS_KM, SAFE_ROUTE_INCIDENT_WINDOW and SAFE_ROUTE_INCIDENT_SATURATION must be positive"))
+	}
+	if a.offHourWeight < 0 || a.offHourWeight > 1 || a.detourWeight < 0 {
+		panic(errors.New("SAFE_ROUTE_OFF_HOUR_WEIGHT must be between 0 and 1 and SAFE_ROUTE_DETOUR_WEIGHT not negative"))
+	}
+	incidents := "zones only, the off-chain index is not configured"
+	if offchain != nil {
+		a.located = offchain.locatedSince
+		incidents = fmt.Sprintf("zones and incidents within %g km over %s", a.incidentRadiusKm, a.incidentWindow)
+	}
+	safeRoutes = a
+	log.Printf("🛣️ Recommending safe routes from %s, scored by %s", base, incidents)
+}
+
+// routeSample is a point along a route, every sampleMeters and at both ends
+type routeSample struct {
+	lat, lng float64
+	meters   float64
+	at       time.Time
+}
+
+// samples walks a route's geometry, placing each sample's time in proportion to its distance
+func (a *routeAdvisor) samples(r engineRoute, departAt time.Time) []routeSample {
+	total := 0.0
+	for i := 1; i < len(r.coordinates); i++ {
+		total += haversineKm(r.coordinates[i-1][1], r.coordinates[i-1][0], r.coordinates[i][1], r.coordinates[i][0]) * 1000
+	}
+	step := math.Max(a.sampleMeters, total/maxRouteSamples)
+	sampleAt := func(lat, lng, meters float64) routeSample {
+		elapsed := 0.0
+		if total > 0 {
+			elapsed = r.duration * meters / total
+		}
+		return routeSample{lat: lat, lng: lng, meters: meters, at: departAt.Add(time.Duration(elapsed * float64(time.Second)))}
+	}
+
+	samples := []routeSample{sampleAt(r.coordinates[0][1], r.coordinates[0][0], 0)}
+	walked, next := 0.0, step
+	for i := 1; i < len(r.coordinates); i++ {
+		from, to := r.coordinates[i-1], r.coordinates[i]
+		length := haversineKm(from[1], from[0], to[1], to[0]) * 1000
+		for length > 0 && next <= walked+length && next < total {
+			f := (next - walked) / length
+			samples = append(samples, sampleAt(from[1]+f*(to[1]-from[1]), from[0]+f*(to[0]-from[0]), next))
+			next += step
+		}
+		walked += length
+	}
+	last := r.coordinates[len(r.coordinates)-1]
+	return append(samples, sampleAt(last[1], last[0], total))
+}
+
+// incidentWeight sums the severity weights of the records near a sample, counting those at another
+// time of day offHourWeight
+func (a *routeAdvisor) incidentWeight(s routeSample, records []locatedRecord) (float64, int) {
+	local := s.at.In(a.location)
+	minute := local.Hour()*60 + local.Minute()
+	weighted, count := 0.0, 0
+	for _, r := range records {
+		if haversineKm(s.lat, s.lng, r.lat, r.lng) > a.incidentRadiusKm {
+			continue
+		}
+		at := r.at.In(a.location)
+		apart := math.Abs(float64(at.Hour()*60 + at.Minute() - minute))
+		apart = math.Min(apart, 24*60-apart)
+		weight := r.weight
+		if apart > a.hourWindow.Minutes() {
+			weight *= a.offHourWeight
+		}
+		weighted += weight
+		count++
+	}
+	return weighted, count
+}
+
+// score rates a route from its samples. A sample's risk combines its zone's and that of the
+// incidents near it, as the chance that either turns out dangerous.
+func (a *routeAdvisor) score(r engineRoute, departAt time.Time, records []locatedRecord) SafeRoute {
+	route := SafeRoute{
+		DistanceMeters:  math.Round(r.distance),
+		DurationSeconds: math.Round(r.duration),
+		Zones:           []string{},
+		Segments:        []RouteSegment{},
+		Geometry:        r.coordinates,
+	}
+	samples := a.samples(r, departAt)
+	var segment *RouteSegment
+	total := 0.0
+	for _, s := range samples {
+		zoneRisk, zoneID, _, _ := zoneRiskAt(s.lat, s.lng, s.at)
+		weighted, count := a.incidentWeight(s, records)
+		risk := 1 - (1-zoneRisk)*(1-weighted/(weighted+a.incidentSaturation))
+		risk = math.Round(risk*100) / 100
+		total += risk
+		route.PeakRisk = math.Max(route.PeakRisk, risk)
+		if zoneID != "" && !slices.Contains(route.Zones, zoneID) {
+			route.Zones = append(route.Zones, zoneID)
+		}
+
+		level := thresholdLevel(a.levels, risk)
+		if zoneRiskLevel(level) < zoneRiskLevel("high") {
+			segment = nil
+			continue
+		}
+		position := [2]float64{s.lng, s.lat}
+		if segment == nil {
+			route.Segments = append(route.Segments, RouteSegment{FromMeters: math.Round(s.meters), Start: position})
+			segment = &route.Segments[len(route.Segments)-1]
+		}
+		segment.ToMeters, segment.End = math.Round(s.meters), position
+		if risk > segment.Risk || segment.RiskLevel == "" {
+			segment.Risk, segment.RiskLevel, segment.ZoneID, segment.Incidents = risk, level, zoneID, count
+		}
+	}
+	route.Risk = math.Round(total/float64(len(samples))*100) / 100
+	route.RiskLevel = thresholdLevel(a.levels, route.Risk)
+	return route
+}
+
+// recommend asks the engine for routes and ranks them by risk plus detourWeight for each multiple of
+// the fastest route's duration they add
+func (a *routeAdvisor) recommend(ctx context.Context, req SafeRouteRequest, departAt time.Time) (SafeRoutes, error) {
+	profile := cmpOr(req.Profile, routeProfiles[0])
+	from := [2]float64{*req.OriginLongitude, *req.OriginLatitude}
+	to := [2]float64{*req.DestinationLongitude, *req.DestinationLatitude}
+	found, err := a.engine.routes(ctx, profile, from, to, a.alternatives)
+	if err != nil {
+		return SafeRoutes{}, err
+	}
+
+	result := SafeRoutes{Profile: profile, DepartAt: departAt.UTC().Format(time.RFC3339)}
+	var records []locatedRecord
+	if a.located != nil {
+		radius := a.incidentRadiusKm / 110.57
+		box := bounds{minLng: 180, minLat: 90, maxLng: -180, maxLat: -90}
+		for _, r := range found {
+			for _, c := range r.coordinates {
+				box = box.union(bounds{minLng: c[0], minLat: c[1], maxLng: c[0], maxLat: c[1]})
+			}
+		}
+		// A degree of longitude narrows away from the equator, so the box is padded by the widest
+		padLng := radius / math.Max(math.Cos(math.Max(math.Abs(box.minLat), math.Abs(box.maxLat))*math.Pi/180), 0.01)
+		box = bounds{minLng: box.minLng - padLng, minLat: box.minLat - radius, maxLng: box.maxLng + padLng, maxLat: box.maxLat + radius}
+		// Without incidents the routes are still rated by their zones
+		if records, err = a.located(ctx, box, departAt.Add(-a.incidentWindow)); err != nil {
+			logWithContext(ctx, "🛣️ Failed to read incidents along routes: %v", err)
+			records = nil
+		} else {
+			result.IncidentsAssessed = true
+		}
+	}
+
+	fastest := found[0].duration
+	for _, r := range found {
+		fastest = math.Min(fastest, r.duration)
+	}
+	result.Routes = make([]SafeRoute, 0, len(found))
+	for _, r := range found {
+		route := a.score(r, departAt, records)
+		route.DetourSeconds = math.Round(r.duration - fastest)
+		route.cost = route.Risk
+		if fastest > 0 {
+			route.cost += a.detourWeight * (r.duration - fastest) / fastest
+		}
+		result.Routes = append(result.Routes, route)
+	}
+	slices.SortStableFunc(result.Routes, func(x, y SafeRoute) int { return cmp.Compare(x.cost, y.cost) })
+	for i := range result.Routes {
+		result.Routes[i].Rank = i + 1
+	}
+	result.Routes[0].Recommended = true
+	return result, nil
+}
+
+// getSafeRoutes serves the tourist app's route planner, so it can steer tourists around high-risk
+// stretches before they set off
+func getSafeRoutes(c *gin.Context) {
+	var req SafeRouteRequest
+	if !bindQuery(c, &req) {
+		return
+	}
+	if safeRoutes == nil {
+		respondError(c, http.StatusServiceUnavailable, errCodeRoutingDisabled, "Safe route recommendations need a routing engine; set SAFE_ROUTE_OSRM_URL")
+		return
+	}
+	departAt := time.Now()
+	if req.DepartAt != "" {
+		departAt, _ = time.Parse(time.RFC3339, req.DepartAt)
+	}
+
+	result, err := safeRoutes.recommend(c.Request.Context(), req, departAt)
+	var routingErr *routingError
+	switch {
+	case errors.Is(err, errNoRoute):
+		respondError(c, http.StatusUnprocessableEntity, errCodeRouteNotFound, "The routing engine found no route between these positions")
+	case errors.As(err, &routingErr):
+		logWithContext(c.Request.Context(), "Failed to find routes: %v", err)
+		respondError(c, http.StatusBadGateway, errCodeRoutingFailed, "The routing engine could not be reached")
+	case err != nil:
+		respondServiceError(c, "Failed to recommend routes", err)
+	default:
+		respondData(c, http.StatusOK, result)
+	}
+}
@@ -0,0 +1,105 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSafeRoutes(t *testing.T) {
+	geofence = newGeofenceIndex()
+	defer func() { geofence = nil }()
+	square := `{"type":"Polygon","coordinates":[[[91.81,25.53],[91.83,25.53],[91.83,25.55],[91.81,25.55],[91.81,25.53]]]}`
+	z, err := newIndexedZone(ZoneDocument{ZoneID: "falls", Name: "Elephant Falls", Geometry: square, RiskLevel: "high"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	geofence.put(z)
+
+	// The fast route crosses the zone; the slow one goes round it to the north
+	var requested string
+	osrm := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = r.URL.String()
+		if strings.Contains(r.URL.Path, "/0.000000,0.000000;") {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"code":"NoRoute","message":"Impossible route between points"}`)
+			return
+		}
+		fmt.Fprint(w, `{"code":"Ok","routes":[
+			{"distance":4020,"duration":600,"geometry":{"type":"LineString","coordinates":[[91.80,25.54],[91.84,25.54]]}},
+			{"distance":8460,"duration":800,"geometry":{"type":"LineString","coordinates":[[91.80,25.54],[91.80,25.56],[91.84,25.56],[91.84,25.54]]}}
+		]}`)
+	}))
+	defer osrm.Close()
+
+	levels, _ := parseZoneRiskThresholds("medium=0.3,high=0.6")
+	location, _ := time.LoadLocation(defaultZoneTimezone)
+	departAt := time.Date(2025, 9, 20, 7, 30, 0, 0, time.UTC) // 13:00 IST
+	records := []locatedRecord{
+		{lat: 25.56, lng: 91.82, at: departAt.Add(-42 * time.Hour), weight: 1},
+		{lat: 25.56, lng: 91.82, at: departAt.Add(-36 * time.Hour), weight: 1},
+	}
+	a := &routeAdvisor{
+		engine:             &osrmRouter{baseURL: osrm.URL, httpClient: osrm.Client()},
+		alternatives:       3,
+		sampleMeters:       100,
+		location:           location,
+		levels:             levels,
+		incidentRadiusKm:   0.3,
+		incidentWindow:     90 * 24 * time.Hour,
+		incidentSaturation: 2,
+		hourWindow:         2 * time.Hour,
+		offHourWeight:      0.5,
+		detourWeight:       0.5,
+		located: func(context.Context, bounds, time.Time) ([]locatedRecord, error) {
+			return records, nil
+		},
+	}
+
+	lat, lng, destLng := 25.54, 91.80, 91.84
+	req := SafeRouteRequest{OriginLatitude: &lat, OriginLongitude: &lng, DestinationLatitude: &lat, DestinationLongitude: &destLng}
+	result, err := a.recommend(context.Background(), req, departAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(requested, "/route/v1/walking/91.800000,25.540000;91.840000,25.540000?alternatives=3") {
+		t.Errorf("unexpected routing request %s", requested)
+	}
+	if !result.IncidentsAssessed || len(result.Routes) != 2 {
+		t.Fatalf("expected two routes scored with incidents, got %+v", result)
+	}
+	safe, direct := result.Routes[0], result.Routes[1]
+	if !safe.Recommended || safe.DurationSeconds != 800 || safe.DetourSeconds != 200 || len(safe.Segments) != 0 || len(safe.Zones) != 0 {
+		t.Errorf("expected the detour recommended without high-risk stretches, got %+v", safe)
+	}
+	if safe.PeakRisk != 0.33 {
+		// Two incidents at another time of day count one between them, scoring 1 / 3
+		t.Errorf("expected the off-hour incidents to peak at 0.33, got %.2f", safe.PeakRisk)
+	}
+	if direct.Rank != 2 || direct.RiskLevel != "medium" || len(direct.Segments) != 1 || direct.Zones[0] != "falls" {
+		t.Fatalf("expected the direct route flagged through the zone, got %+v", direct)
+	}
+	if segment := direct.Segments[0]; segment.ZoneID != "falls" || segment.RiskLevel != "high" || segment.FromMeters < 1000 || segment.ToMeters > 3100 {
+		t.Errorf("unexpected segment %+v", segment)
+	}
+
+	// Incidents at the same time of day count fully
+	records[0].at, records[1].at = departAt.Add(-24*time.Hour), departAt.Add(-48*time.Hour)
+	if weighted, count := a.incidentWeight(routeSample{lat: 25.56, lng: 91.82, at: departAt}, records); weighted != 2 || count != 2 {
+		t.Errorf("expected both incidents at full weight, got %g from %d", weighted, count)
+	}
+
+	zero := 0.0
+	if _, err := a.recommend(context.Background(), SafeRouteRequest{OriginLatitude: &zero, OriginLongitude: &zero, DestinationLatitude: &lat, DestinationLongitude: &destLng}, departAt); !errors.Is(err, errNoRoute) {
+		t.Errorf("expected no route, got %v", err)
+	}
+	missing := SafeRouteRequest{OriginLatitude: &lat, DestinationLatitude: &lat, DestinationLongitude: &destLng, Profile: "flying"}
+	if errs := missing.Validate(); len(errs) != 2 {
+		t.Errorf("expected the missing longitude and the profile rejected, got %v", errs)
+	}
+}
@@ -0,0 +1,723 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	safetyScorePrefix     = "sih:safety:score:"
+	safetyHistoryPrefix   = "sih:safety:history:"
+	safetyPendingKey      = "sih:safety:pending"
+	maxSafetyAnchorsPerTx = 500
+	maxSafetyHistory      = 100
+	safetyDensityTTL      = 5 * time.Minute
+	maxSafetyDensityCells = 10000
+
+	defaultSafetyWeights = "zone=0.35,time=0.1,deviation=0.2,weather=0.15,incidents=0.2"
+)
+
+// safetyComponents are the risks a safety score combines, in the order they are reported
+var safetyComponents = []string{"zone", "time", "deviation", "weather", "incidents"}
+
+// SafetyComponent is one risk behind a safety score, from 0 (none) to 1
+type SafetyComponent struct {
+	Name   string  `json:"name"`
+	Risk   float64 `json:"risk"`
+	Weight float64 `json:"weight"`
+	Detail string  `json:"detail,omitempty"`
+}
+
+// SafetyScore rates how safe a tourist is from 0 (in danger) to 100. Components the gateway cannot
+// assess, such as incident density without the off-chain index, are left out and the remaining
+// weights share the score.
+type SafetyScore struct {
+	DigitalID     string            `json:"digital_id"`
+	Score         int               `json:"score"`
+	Level         string            `json:"level"`
+	LocationKnown bool              `json:"location_known"`
+	Latitude      float64           `json:"latitude"`
+	Longitude     float64           `json:"longitude"`
+	Components    []SafetyComponent `json:"components"`
+	ComputedAt    string            `json:"computed_at"`
+}
+
+// SafetyAnchor is a ledger commitment to a tourist's safety score at the end of an anchoring window
+type SafetyAnchor struct {
+	AnchorID   string `json:"anchor_id"`
+	DigitalID  string `json:"digital_id"`
+	Score      int    `json:"score"`
+	Level      string `json:"level"`
+	Digest     string `json:"digest"`
+	ComputedAt string `json:"computed_at"`
+	AnchoredAt string `json:"anchored_at,omitempty"`
+	TxID       string `json:"tx_id,omitempty"`
+}
+
+// safetyMark is an anomaly remembered for a tourist's deviation history
+type safetyMark struct {
+	Type  string  `json:"type"`
+	Score float64 `json:"score"`
+	At    string  `json:"at"`
+}
+
+// safetyStore keeps each tourist's latest score, their recent anomalies and the tourists whose
+// scores changed since the last anchor
+type safetyStore interface {
+	get(ctx context.Context, digitalID string) (*SafetyScore, error)
+	// put stores the score and queues the tourist for the next anchor
+	put(ctx context.Context, score SafetyScore) error
+	addMarks(ctx context.Context, digitalID string, marks []safetyMark) error
+	marks(ctx context.Context, digitalID string) ([]safetyMark, error)
+	// drainPending removes and returns the queued tourists
+	drainPending(ctx context.Context) ([]string, error)
+}
+
+type safetyEngine struct {
+	store safetyStore
+
+	weights            map[string]float64
+	location           *time.Location
+	nightStart         int // minutes after midnight
+	nightEnd           int
+	historyWindow      time.Duration
+	incidentRadiusKm   float64
+	incidentWindow     time.Duration
+	incidentSaturation int
+	maxAge             time.Duration
+	anchorInterval     time.Duration
+
+	densityMu sync.Mutex
+	density   map[string]safetyDensity
+}
+
+// safetyDensity caches an incident count for a geohash cell
+type safetyDensity struct {
+	count     int
+	countedAt time.Time
+}
+
+var safety *safetyEngine
+
+// initSafety runs after initDocumentCache, initOffchainIndex and initWeather. Anchoring is disabled
+// with SAFETY_ANCHOR_INTERVAL=0.
+func initSafety() {
+	weights, err := parseSafetyWeights(getEnv("SAFETY_WEIGHTS", defaultSafetyWeights))
+	if err != nil {
+		panic(fmt.Errorf("invalid SAFETY_WEIGHTS: %w", err))
+	}
+	timezone := getEnv("SAFETY_TIMEZONE", defaultZoneTimezone)
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		panic(fmt.Errorf("unknown SAFETY_TIMEZONE %q", timezone))
+	}
+	nightStart, nightEnd, err := parseSafetyNight(getEnv("SAFETY_NIGHT_HOURS", "20:00-06:00"))
+	if err != nil {
+		panic(fmt.Errorf("invalid SAFETY_NIGHT_HOURS: %w", err))
+	}
+
+	historyWindow := getEnvDuration("SAFETY_HISTORY_WINDOW", 7*24*time.Hour)
+	scoreTTL := getEnvDuration("SAFETY_SCORE_TTL", 24*time.Hour)
+	var store safetyStore = newMemorySafetyStore(scoreTTL, historyWindow)
+	backend := "memory"
+	if documentCache != nil {
+		store, backend = redisSafetyStore{documentCache, scoreTTL, historyWindow}, "Redis"
+	}
+	safety = &safetyEngine{
+		store:              store,
+		weights:            weights,
+		location:           location,
+		nightStart:         nightStart,
+		nightEnd:           nightEnd,
+		historyWindow:      historyWindow,
+		incidentRadiusKm:   getEnvFloat("SAFETY_INCIDENT_RADIUS_KM", 1),
+		incidentWindow:     getEnvDuration("SAFETY_INCIDENT_WINDOW", 30*24*time.Hour),
+		incidentSaturation: getEnvInt("SAFETY_INCIDENT_SATURATION", 10),
+		maxAge:             getEnvDuration("SAFETY_SCORE_MAX_AGE", 5*time.Minute),
+		anchorInterval:     getEnvDuration("SAFETY_ANCHOR_INTERVAL", time.Hour),
+		density:            map[string]safetyDensity{},
+	}
+	if safety.incidentRadiusKm <= 0 || safety.incidentSaturation <= 0 {
+		panic(fmt.Errorf("SAFETY_INCIDENT_RADIUS_KM and SAFETY_INCIDENT_SATURATION must be positive"))
+	}
+	if safety.anchorInterval <= 0 {
+		log.Printf("🛡️ Safety scores kept in %s, anchoring disabled", backend)
+		return
+	}
+	log.Printf("🛡️ Safety scores kept in %s, anchoring every %s", backend, safety.anchorInterval)
+}
+
+// parseSafetyWeights reads "zone=0.35,time=0.1,...". Components left out weigh nothing.
+func parseSafetyWeights(value string) (map[string]float64, error) {
+	weights := map[string]float64{}
+	total := 0.0
+	for _, entry := range splitList(value) {
+		name, raw, ok := strings.Cut(entry, "=")
+		weight, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+		name = strings.TrimSpace(name)
+		switch {
+		case !ok || err != nil:
+			return nil, fmt.Errorf("%q is not component=weight", entry)
+		case !slices.Contains(safetyComponents, name):
+			return nil, fmt.Errorf("unknown component %q, expected one of %s", name, strings.Join(safetyComponents, ", "))
+		case weight < 0:
+			return nil, fmt.Errorf("weight for %s must not be negative", name)
+		}
+		weights[name] = weight
+		total += weight
+	}
+	if total == 0 {
+		return nil, errors.New("at least one component must have a positive weight")
+	}
+	return weights, nil
+}
+
+// parseSafetyNight reads "20:00-06:00" as minutes after midnight, wrapping past midnight
+func parseSafetyNight(value string) (int, int, error) {
+	from, to, ok := strings.Cut(value, "-")
+	start, err1 := time.Parse("15:04", strings.TrimSpace(from))
+	end, err2 := time.Parse("15:04", strings.TrimSpace(to))
+	if !ok || err1 != nil || err2 != nil {
+		return 0, 0, fmt.Errorf("%q is not HH:MM-HH:MM", value)
+	}
+	return start.Hour()*60 + start.Minute(), end.Hour()*60 + end.Minute(), nil
+}
+
+func safetyLevel(score int) string {
+	switch {
+	case score >= 80:
+		return "safe"
+	case score >= 50:
+		return "caution"
+	}
+	return "danger"
+}
+
+// compute scores a tourist at a position and time
+func (e *safetyEngine) compute(ctx context.Context, digitalID string, lat, lng float64, at time.Time) (SafetyScore, error) {
+	var components []SafetyComponent
+	add := func(name string, risk float64, detail string) {
+		if weight := e.weights[name]; weight > 0 {
+			components = append(components, SafetyComponent{Name: name, Risk: math.Round(math.Min(risk, 1)*100) / 100, Weight: weight, Detail: detail})
+		}
+	}
+
+	// A zone's incident history can rate it above its risk level's share, so the zone component is
+	// whichever is higher
+	zoneRisk, zoneID, riskLevel, scored := zoneRiskAt(lat, lng, at)
+	zoneDetail := ""
+	switch {
+	case scored:
+		zoneDetail = fmt.Sprintf("zone %s scores %.2f from incident history", zoneID, zoneRisk)
+	case riskLevel != "":
+		zoneDetail = riskLevel + " risk zone " + zoneID
+	}
+	add("zone", zoneRisk, zoneDetail)
+
+	local := at.In(e.location)
+	minute := local.Hour()*60 + local.Minute()
+	night := minute >= e.nightStart && minute < e.nightEnd
+	if e.nightStart > e.nightEnd {
+		night = minute >= e.nightStart || minute < e.nightEnd
+	}
+	if night {
+		add("time", 1, "night")
+	} else {
+		add("time", 0, "day")
+	}
+
+	marks, err := e.store.marks(ctx, digitalID)
+	if err != nil {
+		return SafetyScore{}, err
+	}
+	total, count := 0.0, 0
+	for _, mark := range marks {
+		if markedAt, _ := time.Parse(time.RFC3339, mark.At); at.Sub(markedAt) <= e.historyWindow {
+			total, count = total+mark.Score, count+1
+		}
+	}
+	add("deviation", total/3, fmt.Sprintf("%d anomalies in the last %s", count, e.historyWindow))
+
+	if weatherAlerts != nil {
+		alert, err := weatherAt(ctx, lat, lng, at)
+		if err != nil {
+			return SafetyScore{}, err
+		}
+		if alert != nil {
+			add("weather", float64(weatherSeverity(alert.Severity)+1)/float64(len(weatherSeverities)), alert.Severity+": "+alert.Headline)
+		} else {
+			add("weather", 0, "")
+		}
+	}
+
+	if offchain != nil {
+		// Without the index the component is left out rather than failing the score
+		if n, err := e.incidentDensity(ctx, lat, lng, at); err != nil {
+			log.Printf("🛡️ Failed to count incidents near %s: %v", digitalID, err)
+		} else {
+			add("incidents", float64(n)/float64(e.incidentSaturation), fmt.Sprintf("%d incidents and SOS alerts within %g km", n, e.incidentRadiusKm))
+		}
+	}
+
+	weight, risk := 0.0, 0.0
+	for _, c := range components {
+		weight, risk = weight+c.Weight, risk+c.Weight*c.Risk
+	}
+	score := 100
+	if weight > 0 {
+		score = int(math.Round(100 * (1 - risk/weight)))
+	}
+	return SafetyScore{
+		DigitalID:     digitalID,
+		Score:         score,
+		Level:         safetyLevel(score),
+		LocationKnown: true,
+		Latitude:      lat,
+		Longitude:     lng,
+		Components:    components,
+		ComputedAt:    at.UTC().Format(time.RFC3339),
+	}, nil
+}
+
+// incidentDensity counts the incidents and SOS alerts near a position over SAFETY_INCIDENT_WINDOW.
+// Counts are cached per ledger geohash cell, so a stream of pings does not query the index each time.
+func (e *safetyEngine) incidentDensity(ctx context.Context, lat, lng float64, at time.Time) (int, error) {
+	cell := encodeGeohash(lat, lng, ledgerGeohashPrecision)
+	e.densityMu.Lock()
+	cached, ok := e.density[cell]
+	e.densityMu.Unlock()
+	if ok && time.Since(cached.countedAt) < safetyDensityTTL {
+		return cached.count, nil
+	}
+
+	n, err := offchain.countLocated(ctx, lat, lng, e.incidentRadiusKm, at.Add(-e.incidentWindow))
+	if err != nil {
+		return 0, err
+	}
+	e.densityMu.Lock()
+	if len(e.density) >= maxSafetyDensityCells {
+		e.density = map[string]safetyDensity{}
+	}
+	e.density[cell] = safetyDensity{count: n, countedAt: time.Now()}
+	e.densityMu.Unlock()
+	return n, nil
+}
+
+// countLocated counts the incidents and SOS alerts since a time in the box around a radius
+func (ix *offchainIndex) countLocated(ctx context.Context, lat, lng, radiusKm float64, since time.Time) (int, error) {
+	dLat := radiusKm / 110.57
+	dLng := radiusKm / (111.32 * math.Max(math.Cos(lat*math.Pi/180), 0.01))
+	var f queryFilter
+	f.add("latitude BETWEEN ? AND ?", lat-dLat, lat+dLat)
+	f.add("longitude BETWEEN ? AND ?", lng-dLng, lng+dLng)
+	f.add("at >= ?", since.UTC().Format(time.RFC3339))
+	rows, err := ix.db.Query(ctx, `SELECT count(*) FROM (
+			SELECT latitude, longitude, created_at AS at FROM incidents WHERE deleted_at IS NULL AND geohash <> ''
+			UNION ALL
+			SELECT latitude, longitude, raised_at AS at FROM sos_alerts WHERE geohash <> ''
+		) located WHERE `+f.where(), f.args...)
+	if err != nil || len(rows) == 0 {
+		return 0, err
+	}
+	return int(rows[0].Int(0)), nil
+}
+
+// update records a tourist's new anomalies and rescores them at their live position. A fall to a
+// worse level than the stored score's is an alert.
+func (e *safetyEngine) update(ctx context.Context, digitalID string, lat, lng float64, events []AnomalyEvent) (SafetyScore, error) {
+	if len(events) > 0 {
+		marks := make([]safetyMark, 0, len(events))
+		for _, event := range events {
+			marks = append(marks, safetyMark{Type: event.Type, Score: event.Score, At: event.At})
+		}
+		if err := e.store.addMarks(ctx, digitalID, marks); err != nil {
+			return SafetyScore{}, err
+		}
+	}
+	previous, err := e.store.get(ctx, digitalID)
+	if err != nil {
+		return SafetyScore{}, err
+	}
+	score, err := e.compute(ctx, digitalID, lat, lng, time.Now())
+	if err != nil {
+		return SafetyScore{}, err
+	}
+	if err := e.store.put(ctx, score); err != nil {
+		return SafetyScore{}, err
+	}
+	if previous != nil && safetyLevelRank(score.Level) > safetyLevelRank(previous.Level) {
+		e.dropped(ctx, *previous, score)
+	}
+	return score, nil
+}
+
+// safetyLevelRank orders levels from safe to danger
+func safetyLevelRank(level string) int {
+	return slices.Index([]string{"safe", "caution", "danger"}, level)
+}
+
+// safetyDropID is stable for a drop, so its operator alerts and decision are recorded once
+func safetyDropID(digitalID, at string) string {
+	computedAt, _ := time.Parse(time.RFC3339, at)
+	sum := sha256.Sum256([]byte(digitalID + "|" + alertSafetyDrop))
+	return "SDROP-" + computedAt.UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(sum[:5])
+}
+
+// dropped alerts the tourist's operators of a fall in safety level and anchors the decision
+func (e *safetyEngine) dropped(ctx context.Context, previous, score SafetyScore) {
+	alertID := safetyDropID(score.DigitalID, score.ComputedAt)
+	log.Printf("🛡️ Safety of %s fell from %s to %s", score.DigitalID, previous.Level, score.Level)
+	if operators != nil {
+		operators.safetyDrop(ctx, alertID, previous, score)
+	}
+	decisions.safetyDrop(ctx, alertID, previous, score)
+}
+
+// observe rescores the tourists behind anomalies raised outside an upload, such as signal loss
+func (e *safetyEngine) observe(ctx context.Context, events []AnomalyEvent) {
+	byTourist := map[string][]AnomalyEvent{}
+	for _, event := range events {
+		byTourist[event.DigitalID] = append(byTourist[event.DigitalID], event)
+	}
+	for _, digitalID := range sortedKeys(byTourist) {
+		last := byTourist[digitalID][len(byTourist[digitalID])-1]
+		if _, err := e.update(ctx, digitalID, last.Latitude, last.Longitude, byTourist[digitalID]); err != nil {
+			log.Printf("🛡️ Failed to rescore %s: %v", digitalID, err)
+		}
+	}
+}
+
+// current returns the stored score, rescoring at the live location once it is older than
+// SAFETY_SCORE_MAX_AGE so that new weather alerts, incidents and the time of day are reflected.
+// It returns nil for a tourist with neither a score nor a live location.
+func (e *safetyEngine) current(ctx context.Context, digitalID string) (*SafetyScore, error) {
+	stored, err := e.store.get(ctx, digitalID)
+	if err != nil {
+		return nil, err
+	}
+	if stored != nil {
+		if computedAt, _ := time.Parse(time.RFC3339, stored.ComputedAt); time.Since(computedAt) < e.maxAge {
+			return stored, nil
+		}
+	}
+	var live *LiveLocation
+	if locations != nil {
+		if live, err = locations.store.latest(ctx, digitalID); err != nil {
+			return nil, err
+		}
+	}
+	if live == nil {
+		return stored, nil
+	}
+	score, err := e.update(ctx, digitalID, live.Latitude, live.Longitude, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &score, nil
+}
+
+// run anchors the changed scores every SAFETY_ANCHOR_INTERVAL on the default target. Each window is
+// claimed, so with several gateway instances only one anchors it.
+func (e *safetyEngine) run(ctx context.Context) {
+	if e.anchorInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(e.anchorInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case tick := <-ticker.C:
+			window := tick.UTC().Truncate(e.anchorInterval)
+			if claimed, err := claimEvent(ctx, "safety-anchor:"+window.Format(time.RFC3339), e.anchorInterval); err != nil || !claimed {
+				continue
+			}
+			if err := e.anchor(withTarget(ctx, defaultTarget), window); err != nil {
+				log.Printf("Failed to anchor safety scores: %v", err)
+			}
+		}
+	}
+}
+
+// anchor records the latest score of every tourist rescored since the last window. Tourists whose
+// transaction fails are queued again for the next window.
+func (e *safetyEngine) anchor(ctx context.Context, window time.Time) error {
+	pending, err := e.store.drainPending(ctx)
+	if err != nil || len(pending) == 0 {
+		return err
+	}
+	sort.Strings(pending)
+
+	anchors := make([]SafetyAnchor, 0, len(pending))
+	scores := map[string]SafetyScore{}
+	for _, digitalID := range pending {
+		score, err := e.store.get(ctx, digitalID)
+		if err != nil {
+			return err
+		}
+		if score == nil {
+			continue
+		}
+		canonical, err := json.Marshal(score)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(canonical)
+		scores[digitalID] = *score
+		anchors = append(anchors, SafetyAnchor{
+			AnchorID:   "SAFE:" + digitalID + ":" + window.Format("20060102T150405Z"),
+			DigitalID:  digitalID,
+			Score:      score.Score,
+			Level:      score.Level,
+			Digest:     hex.EncodeToString(sum[:]),
+			ComputedAt: score.ComputedAt,
+		})
+	}
+
+	var errs []error
+	anchored := 0
+	for start := 0; start < len(anchors); start += maxSafetyAnchorsPerTx {
+		chunk := anchors[start:min(start+maxSafetyAnchorsPerTx, len(anchors))]
+		payload, err := json.Marshal(chunk)
+		if err == nil {
+			_, err = submitTransaction(ctx, "AnchorSafetyScores", string(payload))
+		}
+		if err != nil {
+			errs = append(errs, err)
+			for _, anchor := range chunk {
+				if requeueErr := e.store.put(ctx, scores[anchor.DigitalID]); requeueErr != nil {
+					log.Printf("Dropped the safety score anchor for %s: %v", anchor.DigitalID, requeueErr)
+				}
+			}
+			continue
+		}
+		anchored += len(chunk)
+	}
+	if anchored > 0 {
+		log.Printf("🛡️ Anchored safety scores for %d tourists", anchored)
+	}
+	return errors.Join(errs...)
+}
+
+func (ledgerService) ListSafetyAnchors(ctx context.Context, digitalID string) ([]SafetyAnchor, error) {
+	if errs := validateDocumentID("digitalId", digitalID); len(errs) > 0 {
+		return nil, errs
+	}
+	result, err := evaluateTransaction(ctx, "GetSafetyAnchors", digitalID)
+	if err != nil {
+		return nil, err
+	}
+	anchors, err := decodeDocument[[]SafetyAnchor](result, "safety anchor")
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(anchors, func(i, j int) bool { return anchors[i].AnchorID < anchors[j].AnchorID })
+	return anchors, nil
+}
+
+type redisSafetyStore struct {
+	redis         *redisClient
+	scoreTTL      time.Duration
+	historyWindow time.Duration
+}
+
+func (s redisSafetyStore) get(ctx context.Context, digitalID string) (*SafetyScore, error) {
+	data, err := s.redis.Get(ctx, safetyScorePrefix+digitalID)
+	if errors.Is(err, errRedisNil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var score SafetyScore
+	if err := json.Unmarshal(data, &score); err != nil {
+		return nil, err
+	}
+	return &score, nil
+}
+
+func (s redisSafetyStore) put(ctx context.Context, score SafetyScore) error {
+	data, err := json.Marshal(score)
+	if err != nil {
+		return err
+	}
+	if err := s.redis.Set(ctx, safetyScorePrefix+score.DigitalID, data, s.scoreTTL); err != nil {
+		return err
+	}
+	_, err = s.redis.Do(ctx, "SADD", safetyPendingKey, score.DigitalID)
+	return err
+}
+
+func (s redisSafetyStore) addMarks(ctx context.Context, digitalID string, marks []safetyMark) error {
+	key := safetyHistoryPrefix + digitalID
+	args := []string{"LPUSH", key}
+	for _, mark := range marks {
+		data, err := json.Marshal(mark)
+		if err != nil {
+			return err
+		}
+		args = append(args, string(data))
+	}
+	for _, command := range [][]string{args, {"LTRIM", key, "0", strconv.Itoa(maxSafetyHistory - 1)}, {"PEXPIRE", key, strconv.FormatInt(s.historyWindow.Milliseconds(), 10)}} {
+		if _, err := s.redis.Do(ctx, command...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s redisSafetyStore) marks(ctx context.Context, digitalID string) ([]safetyMark, error) {
+	reply, err := s.redis.Do(ctx, "LRANGE", safetyHistoryPrefix+digitalID, "0", "-1")
+	if err != nil {
+		return nil, err
+	}
+	items, _ := reply.([]interface{})
+	marks := make([]safetyMark, 0, len(items))
+	for _, item := range items {
+		raw, _ := item.([]byte)
+		var mark safetyMark
+		if json.Unmarshal(raw, &mark) == nil {
+			marks = append(marks, mark)
+		}
+	}
+	return marks, nil
+}
+
+// drainPending claims each member with SREM, so a tourist is anchored by one instance
+func (s redisSafetyStore) drainPending(ctx context.Context) ([]string, error) {
+	reply, err := s.redis.Do(ctx, "SMEMBERS", safetyPendingKey)
+	if err != nil {
+		return nil, err
+	}
+	members, _ := reply.([]interface{})
+	var pending []string
+	for _, member := range members {
+		raw, _ := member.([]byte)
+		removed, err := s.redis.Do(ctx, "SREM", safetyPendingKey, string(raw))
+		if err != nil {
+			return pending, err
+		}
+		if n, _ := removed.(int64); n == 1 {
+			pending = append(pending, string(raw))
+		}
+	}
+	return pending, nil
+}
+
+// memorySafetyStore serves a single gateway instance
+type memorySafetyStore struct {
+	scoreTTL      time.Duration
+	historyWindow time.Duration
+
+	mu      sync.Mutex
+	scores  map[string]SafetyScore
+	savedAt map[string]time.Time
+	history map[string][]safetyMark
+	pending map[string]bool
+}
+
+func newMemorySafetyStore(scoreTTL, historyWindow time.Duration) *memorySafetyStore {
+	return &memorySafetyStore{
+		scoreTTL: scoreTTL, historyWindow: historyWindow,
+		scores: map[string]SafetyScore{}, savedAt: map[string]time.Time{}, history: map[string][]safetyMark{}, pending: map[string]bool{},
+	}
+}
+
+func (s *memorySafetyStore) get(_ context.Context, digitalID string) (*SafetyScore, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	score, ok := s.scores[digitalID]
+	if !ok || time.Since(s.savedAt[digitalID]) > s.scoreTTL {
+		return nil, nil
+	}
+	return &score, nil
+}
+
+func (s *memorySafetyStore) put(_ context.Context, score SafetyScore) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scores[score.DigitalID], s.savedAt[score.DigitalID] = score, time.Now()
+	s.pending[score.DigitalID] = true
+	return nil
+}
+
+func (s *memorySafetyStore) addMarks(_ context.Context, digitalID string, marks []safetyMark) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	history := s.history[digitalID]
+	for _, mark := range marks {
+		history = append([]safetyMark{mark}, history...)
+	}
+	s.history[digitalID] = history[:min(len(history), maxSafetyHistory)]
+	return nil
+}
+
+func (s *memorySafetyStore) marks(_ context.Context, digitalID string) ([]safetyMark, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]safetyMark(nil), s.history[digitalID]...), nil
+}
+
+func (s *memorySafetyStore) drainPending(context.Context) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pending := sortedKeys(s.pending)
+	s.pending = map[string]bool{}
+	return pending, nil
+}
+
+func getSafetyScore(c *gin.Context) {
+	id, ok := validPathID(c, "digitalId")
+	if !ok {
+		return
+	}
+
+	score, err := safety.current(c.Request.Context(), id)
+	if err != nil {
+		respondServiceError(c, "Failed to compute safety score", err)
+		return
+	}
+	if score == nil {
+		respondError(c, http.StatusNotFound, errCodeNotFound, "No location has been reported for this tourist")
+		return
+	}
+
+	respondData(c, http.StatusOK, score)
+}
+
+func getSafetyAnchors(c *gin.Context) {
+	id, ok := validPathID(c, "digitalId")
+	if !ok {
+		return
+	}
+
+	anchors, err := ledger.ListSafetyAnchors(c.Request.Context(), id)
+	if err != nil {
+		respondServiceError(c, "Failed to list safety anchors", err)
+		return
+	}
+
+	respondData(c, http.StatusOK, anchors)
+}
@@ -0,0 +1,83 @@
+package gateway
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSafetyScore(t *testing.T) {
+	geofence = newGeofenceIndex()
+	previousWeather := weatherAlerts
+	weatherAlerts = newMemoryWeatherStore()
+	defer func() { geofence, weatherAlerts = nil, previousWeather }()
+	ctx := context.Background()
+
+	cliff, err := newIndexedZone(ZoneDocument{ZoneID: "cliff", Name: "Cliff edge", Geometry: `{"type":"Circle","coordinates":[91.885,25.575],"radius":500}`, RiskLevel: "restricted"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	geofence.put(cliff)
+
+	weights, err := parseSafetyWeights(defaultSafetyWeights)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nightStart, nightEnd, _ := parseSafetyNight("20:00-06:00")
+	ist, _ := time.LoadLocation("Asia/Kolkata")
+	e := &safetyEngine{
+		store: newMemorySafetyStore(time.Hour, 24*time.Hour), weights: weights, location: ist,
+		nightStart: nightStart, nightEnd: nightEnd, historyWindow: 24 * time.Hour, maxAge: time.Minute,
+	}
+	id := "did:sih:tourist_001"
+
+	// By day in open country with no history: nothing to deduct. Incident density is left out
+	// without the off-chain index.
+	noon := time.Date(2025, 9, 20, 6, 30, 0, 0, time.UTC)
+	score, err := e.compute(ctx, id, 25.60, 91.95, noon)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if score.Score != 100 || score.Level != "safe" || len(score.Components) != 4 {
+		t.Errorf("expected a clean score from four components, got %+v", score)
+	}
+
+	// At night on the cliff, under a red warning, after two anomalies: the zone, time and weather
+	// risks are full and deviation is two-thirds, so the risk is (0.35+0.1+0.15+0.2*2/3)/0.8
+	rain := GeoJSONGeometry{Type: "Circle", Coordinates: []float64{91.88, 25.57}, Radius: 5000}
+	expires := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	if errs := (WeatherAlertRequest{Severity: "warning", Headline: "Heavy rain", Geometry: &rain, ExpiresAt: expires}).Validate(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	weatherAlerts.put(ctx, WeatherAlert{AlertID: "IMD-1", Severity: "warning", Headline: "Heavy rain", Geometry: rain, ExpiresAt: expires})
+	events := []AnomalyEvent{{Type: anomalyInactivity, Score: 1, At: time.Now().UTC().Format(time.RFC3339)}, {Type: anomalySpeedJump, Score: 1, At: time.Now().UTC().Format(time.RFC3339)}}
+	score, err = e.update(ctx, id, 25.575, 91.885, events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	night, err := e.compute(ctx, id, 25.575, 91.885, time.Date(2025, 9, 20, 17, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if night.Score != 8 || night.Level != "danger" || !strings.Contains(night.Components[3].Detail, "Heavy rain") {
+		t.Errorf("expected 8 at night on the cliff, got %+v", night)
+	}
+
+	// Rescored tourists are anchored once per window
+	if stored, _ := e.store.get(ctx, id); stored == nil || stored.Score != score.Score {
+		t.Errorf("expected the score stored, got %+v", stored)
+	}
+	if pending, _ := e.store.drainPending(ctx); len(pending) != 1 || pending[0] != id {
+		t.Errorf("expected the tourist queued for anchoring, got %v", pending)
+	}
+	if pending, _ := e.store.drainPending(ctx); len(pending) != 0 {
+		t.Errorf("expected the queue drained, got %v", pending)
+	}
+
+	for _, bad := range []string{"zone=1,mood=1", "zone=-1", "zone=0", "zone"} {
+		if _, err := parseSafetyWeights(bad); err == nil {
+			t.Errorf("expected %q rejected", bad)
+		}
+	}
+}
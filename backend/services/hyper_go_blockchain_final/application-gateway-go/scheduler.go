@@ -0,0 +1,688 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	jobStatusKey  = "sih:jobs:status"
+	jobLockPrefix = "sih:jobs:lock:"
+
+	errCodeJobRunning = "JOB_RUNNING"
+
+	// A cron schedule that matches nothing within this many years, such as "0 0 30 2 *", is refused
+	maxScheduleYears = 5
+)
+
+// Scheduled jobs
+const (
+	jobDIDExpiry       = "did-expiry"
+	jobRevocationList  = "revocation-list"
+	jobDIDBundle       = "did-bundle"
+	jobReports         = "reports"
+	jobIndexCompaction = "index-compaction"
+	jobArchive         = "archive"
+	jobParquetExport   = "parquet-export"
+)
+
+// Outcomes of a job run
+const (
+	jobSucceeded = "succeeded"
+	jobFailed    = "failed"
+)
+
+// releaseJobLock deletes a lock only while it still holds this instance's token, so a run that
+// outlived its lock cannot release the next holder's
+const releaseJobLock = `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("DEL", KEYS[1]) end return 0`
+
+var errJobRunning = errors.New("the job is already running")
+
+// jobSchedule is a five-field cron expression (minute, hour, day of month, month, day of week) in
+// the scheduler's timezone, or "@every <duration>"
+type jobSchedule struct {
+	spec  string
+	every time.Duration
+	// fields hold the allowed values of each cron field as bits
+	minute, hour, dom, month, dow uint64
+	// domAny and dowAny record a "*" day field: cron matches either day field when both are
+	// restricted, and the restricted one otherwise
+	domAny, dowAny bool
+}
+
+var scheduleMacros = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+}
+
+func parseJobSchedule(spec string) (*jobSchedule, error) {
+	spec = strings.TrimSpace(spec)
+	s := &jobSchedule{spec: spec}
+	if value, ok := strings.CutPrefix(spec, "@every "); ok {
+		every, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil || every < time.Second {
+			return nil, fmt.Errorf("schedule %q: @every needs a duration of at least 1s", spec)
+		}
+		s.every = every
+		return s, nil
+	}
+	if expanded, ok := scheduleMacros[spec]; ok {
+		spec = expanded
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("schedule %q: expected five cron fields or @every <duration>", s.spec)
+	}
+	bounds := []struct {
+		field    *uint64
+		min, max int
+	}{{&s.minute, 0, 59}, {&s.hour, 0, 23}, {&s.dom, 1, 31}, {&s.month, 1, 12}, {&s.dow, 0, 7}}
+	for i, b := range bounds {
+		bits, err := parseCronField(fields[i], b.min, b.max)
+		if err != nil {
+			return nil, fmt.Errorf("schedule %q: field %d: %w", s.spec, i+1, err)
+		}
+		*b.field = bits
+	}
+	// Sunday is 0 or 7
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.domAny, s.dowAny = fields[2] == "*", fields[4] == "*"
+	if s.next(time.Now(), time.UTC).IsZero() {
+		return nil, fmt.Errorf("schedule %q never runs", s.spec)
+	}
+	return s, nil
+}
+
+// parseCronField accepts "*", values, ranges "a-b" and steps "*/n" or "a-b/n", separated by commas
+func parseCronField(field string, lo, hi int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepPart)
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("invalid step %q", stepPart)
+			}
+			step = n
+		}
+		start, end := lo, hi
+		if rangePart != "*" {
+			first, last, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if start, err = strconv.Atoi(first); err != nil {
+				return 0, fmt.Errorf("invalid value %q", first)
+			}
+			end = start
+			if isRange {
+				if end, err = strconv.Atoi(last); err != nil {
+					return 0, fmt.Errorf("invalid value %q", last)
+				}
+			} else if hasStep {
+				end = hi
+			}
+		}
+		if start < lo || end > hi || start > end {
+			return 0, fmt.Errorf("%q is outside %d-%d", part, lo, hi)
+		}
+		for v := start; v <= end; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+func (s *jobSchedule) dayMatches(t time.Time) bool {
+	dom, dow := s.dom&(1<<t.Day()) != 0, s.dow&(1<<int(t.Weekday())) != 0
+	if s.domAny || s.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// next returns the first time after t the schedule fires, or the zero time if it never does.
+// @every schedules fire on multiples of their interval, so every instance agrees on the times.
+func (s *jobSchedule) next(after time.Time, location *time.Location) time.Time {
+	if s.every > 0 {
+		return after.Truncate(s.every).Add(s.every)
+	}
+	t := after.In(location).Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(maxScheduleYears, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<int(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, location)
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, location)
+		case s.hour&(1<<t.Hour()) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, location)
+		case s.minute&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// scheduledJob is a recurring maintenance task
+type scheduledJob struct {
+	name        string
+	description string
+	schedule    *jobSchedule
+	// local jobs run on every instance, for state each instance keeps, such as the signed
+	// revocation list; others run on one instance per scheduled time
+	local   bool
+	enabled bool
+	timeout time.Duration
+	// retry brings the next run forward after a failure, when shorter than the wait for the schedule
+	retry time.Duration
+	run   func(ctx context.Context, now time.Time) error
+
+	trigger chan struct{}
+	mu      sync.Mutex
+	running bool
+	nextRun time.Time
+}
+
+// JobRun is one run of a job
+type JobRun struct {
+	RunID      string `json:"run_id"`
+	Trigger    string `json:"trigger"`
+	Instance   string `json:"instance"`
+	StartedAt  string `json:"started_at"`
+	FinishedAt string `json:"finished_at,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+}
+
+// jobRecord is a job's history, shared by every instance for jobs that run on one of them
+type jobRecord struct {
+	LastRun             *JobRun `json:"last_run,omitempty"`
+	LastSuccessAt       string  `json:"last_success_at,omitempty"`
+	LastFailureAt       string  `json:"last_failure_at,omitempty"`
+	ConsecutiveFailures int     `json:"consecutive_failures"`
+	Runs                int     `json:"runs"`
+	Failures            int     `json:"failures"`
+}
+
+// JobStatus describes a job and how its last run went
+type JobStatus struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Schedule    string `json:"schedule"`
+	Local       bool   `json:"local"`
+	Enabled     bool   `json:"enabled"`
+	// Running and NextRunAt are as seen by the instance that answered
+	Running             bool    `json:"running"`
+	NextRunAt           string  `json:"next_run_at,omitempty"`
+	LastRun             *JobRun `json:"last_run,omitempty"`
+	LastSuccessAt       string  `json:"last_success_at,omitempty"`
+	LastFailureAt       string  `json:"last_failure_at,omitempty"`
+	ConsecutiveFailures int     `json:"consecutive_failures"`
+	Runs                int     `json:"runs"`
+	Failures            int     `json:"failures"`
+}
+
+type RunJobRequest struct {
+	Actor string `json:"actor" binding:"required"`
+}
+
+func (r RunJobRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.identifier("actor", r.Actor)
+	return v.errors
+}
+
+// jobStore keeps job records in Redis when the document cache is configured, and in memory
+// otherwise. Records of local jobs always stay in memory, since they describe this instance.
+type jobStore interface {
+	get(ctx context.Context, name string) (jobRecord, error)
+	put(ctx context.Context, name string, record jobRecord) error
+}
+
+// jobScheduler runs the recurring jobs. A scheduled run is claimed for its time, so one instance
+// runs it, and holds a lock while it runs, so a slow run is never overlapped.
+type jobScheduler struct {
+	jobs     map[string]*scheduledJob
+	order    []string
+	shared   jobStore
+	local    jobStore
+	location *time.Location
+	instance string
+}
+
+var scheduler *jobScheduler
+
+// initScheduler runs after the services whose jobs it schedules: initErasure, initQRRevocations,
+// initDIDBundle, initReports, initOffchainIndex and initArchive
+func initScheduler() {
+	timezone := getEnv("JOBS_TIMEZONE", defaultZoneTimezone)
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		panic(fmt.Errorf("JOBS_TIMEZONE %q is not a known timezone", timezone))
+	}
+	s := &jobScheduler{
+		jobs:     map[string]*scheduledJob{},
+		shared:   newMemoryJobStore(),
+		local:    newMemoryJobStore(),
+		location: location,
+		instance: newInstanceID(),
+	}
+	backend := "memory"
+	if documentCache != nil {
+		s.shared, backend = redisJobStore{documentCache}, "Redis"
+	}
+
+	if eraser != nil && eraser.window > 0 {
+		s.register(&scheduledJob{name: jobDIDExpiry, description: "Erases the personal data of DIDs expired longer than RETENTION_WINDOW",
+			schedule: everySchedule(eraser.interval), timeout: time.Hour, run: eraser.sweep})
+	}
+	if qrRevocations != nil {
+		s.register(&scheduledJob{name: jobRevocationList, description: "Re-signs the DID revocation list from the ledger",
+			schedule: everySchedule(qrRevocations.interval), local: true, timeout: time.Minute, retry: 10 * time.Second, run: qrRevocations.publish})
+	}
+	if didBundles != nil {
+		s.register(&scheduledJob{name: jobDIDBundle, description: "Re-signs the offline DID bundle from the ledger",
+			schedule: everySchedule(didBundles.interval), local: true, timeout: 10 * time.Minute, retry: time.Minute, run: didBundles.publish})
+	}
+	if reports != nil && len(reports.schedule) > 0 {
+		s.register(&scheduledJob{name: jobReports, description: "Generates the scheduled operations reports once their period is over",
+			schedule: everySchedule(reportCheckInterval), timeout: 30 * time.Minute, run: reports.generateDue})
+	}
+	if offchain != nil {
+		retention := getEnvDuration("INDEX_TOMBSTONE_RETENTION", 30*24*time.Hour)
+		if retention <= 0 {
+			panic(errors.New("INDEX_TOMBSTONE_RETENTION must be positive"))
+		}
+		s.register(&scheduledJob{name: jobIndexCompaction, description: "Purges index rows deleted longer than INDEX_TOMBSTONE_RETENTION and vacuums the tables",
+			schedule: mustJobSchedule("30 3 * * *"), timeout: time.Hour,
+			run: func(ctx context.Context, now time.Time) error { return offchain.compact(ctx, now.Add(-retention)) }})
+	}
+	if archiver != nil {
+		s.register(&scheduledJob{name: jobArchive, description: "Moves incidents resolved longer than ARCHIVE_AFTER_MONTHS to cold storage",
+			schedule: everySchedule(archiver.interval), timeout: 6 * time.Hour, run: archiver.sweep})
+	}
+	if parquetExport != nil {
+		// 06:00 in the default Asia/Kolkata is just after the UTC day, which partitions the files, ends
+		s.register(&scheduledJob{name: jobParquetExport, description: "Exports the days of indexed incidents, evidence and audits that changed to Parquet files",
+			schedule: mustJobSchedule("0 6 * * *"), timeout: 6 * time.Hour, retry: 15 * time.Minute, run: parquetExport.run})
+	}
+
+	disabled := splitList(getEnv("JOBS_DISABLED", ""))
+	for _, name := range disabled {
+		if _, ok := s.jobs[name]; !ok {
+			panic(fmt.Errorf("JOBS_DISABLED names %q, which is not a job; jobs are %s", name, strings.Join(s.order, ", ")))
+		}
+	}
+	for _, name := range s.order {
+		job := s.jobs[name]
+		job.enabled = !slices.Contains(disabled, name)
+		if spec := getEnv("JOB_"+strings.ToUpper(strings.ReplaceAll(name, "-", "_"))+"_SCHEDULE", ""); spec != "" {
+			schedule, err := parseJobSchedule(spec)
+			if err != nil {
+				panic(fmt.Errorf("job %s: %w", name, err))
+			}
+			job.schedule = schedule
+		}
+	}
+	scheduler = s
+	log.Printf("⏰ Scheduling %d jobs in %s, with history in %s", len(s.order), timezone, backend)
+}
+
+func everySchedule(interval time.Duration) *jobSchedule {
+	return &jobSchedule{spec: "@every " + interval.String(), every: interval}
+}
+
+func mustJobSchedule(spec string) *jobSchedule {
+	schedule, err := parseJobSchedule(spec)
+	if err != nil {
+		panic(err)
+	}
+	return schedule
+}
+
+func (s *jobScheduler) register(job *scheduledJob) {
+	job.enabled = true
+	job.trigger = make(chan struct{}, 1)
+	s.jobs[job.name] = job
+	s.order = append(s.order, job.name)
+}
+
+func (s *jobScheduler) store(job *scheduledJob) jobStore {
+	if job.local {
+		return s.local
+	}
+	return s.shared
+}
+
+func (s *jobScheduler) run(ctx context.Context) {
+	for _, name := range s.order {
+		if job := s.jobs[name]; job.enabled {
+			go s.loop(ctx, job)
+		}
+	}
+}
+
+// trigger runs a local job on this instance as soon as it is free, such as the revocation list
+// after a DID is deleted
+func (s *jobScheduler) trigger(name string) {
+	if s == nil || s.jobs[name] == nil {
+		return
+	}
+	select {
+	case s.jobs[name].trigger <- struct{}{}:
+	default:
+	}
+}
+
+// loop runs a job at each scheduled time. A job that has never run, or missed a scheduled time
+// while no instance was up, runs at once.
+func (s *jobScheduler) loop(ctx context.Context, job *scheduledJob) {
+	now := time.Now()
+	slot := job.schedule.next(now, s.location)
+	if record, err := s.store(job).get(ctx, job.name); err != nil {
+		log.Printf("⏰ Failed to read the history of job %s: %v", job.name, err)
+	} else if record.LastRun == nil {
+		slot = time.Time{}
+	} else if started, err := time.Parse(time.RFC3339, record.LastRun.StartedAt); err == nil {
+		if missed := job.schedule.next(started, s.location); !missed.After(now) {
+			slot = missed
+		}
+	}
+
+	for {
+		job.mu.Lock()
+		job.nextRun = slot
+		job.mu.Unlock()
+		timer := time.NewTimer(time.Until(slot))
+		trigger := "schedule"
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-job.trigger:
+			timer.Stop()
+			trigger = "event"
+		case <-timer.C:
+		}
+
+		failed := false
+		if trigger == "event" || s.claimSlot(ctx, job, slot) {
+			run, err := s.start(ctx, job, trigger)
+			if err == nil {
+				failed = s.finish(ctx, job, run).Status == jobFailed
+			} else if !errors.Is(err, errJobRunning) {
+				log.Printf("⏰ Failed to start job %s: %v", job.name, err)
+			}
+		}
+
+		now := time.Now()
+		next := job.schedule.next(now, s.location)
+		if failed && job.retry > 0 && now.Add(job.retry).Before(next) {
+			next = now.Add(job.retry)
+		}
+		if trigger == "event" && slot.After(now) {
+			next = slot
+		}
+		slot = next
+	}
+}
+
+// claimSlot takes a scheduled time for this instance. Local jobs run everywhere, so need no claim.
+func (s *jobScheduler) claimSlot(ctx context.Context, job *scheduledJob, slot time.Time) bool {
+	if job.local {
+		return true
+	}
+	claimed, err := claimEvent(ctx, fmt.Sprintf("job:%s:%d", job.name, slot.Unix()), max(job.timeout, time.Hour))
+	if err != nil {
+		log.Printf("⏰ Failed to claim job %s: %v", job.name, err)
+	}
+	return claimed
+}
+
+// start marks the job running and, unless it is local, takes its lock across instances
+func (s *jobScheduler) start(ctx context.Context, job *scheduledJob, trigger string) (*JobRun, error) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	if job.running {
+		return nil, errJobRunning
+	}
+	run := &JobRun{RunID: newJobRunID(), Trigger: trigger, Instance: s.instance, StartedAt: time.Now().UTC().Format(time.RFC3339)}
+	if !job.local && documentCache != nil {
+		_, err := documentCache.Do(ctx, "SET", jobLockPrefix+job.name, run.RunID, "NX", "PX", strconv.FormatInt(job.timeout.Milliseconds(), 10))
+		if errors.Is(err, errRedisNil) {
+			return nil, errJobRunning
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	job.running = true
+	return run, nil
+}
+
+// finish runs a started job, releases it and records the outcome
+func (s *jobScheduler) finish(ctx context.Context, job *scheduledJob, run *JobRun) JobRun {
+	started := time.Now()
+	runCtx, cancel := context.WithTimeout(withTarget(ctx, defaultTarget), job.timeout)
+	err := job.run(runCtx, started)
+	cancel()
+
+	ctx = context.WithoutCancel(ctx)
+	if !job.local && documentCache != nil {
+		if _, err := documentCache.Do(ctx, "EVAL", releaseJobLock, "1", jobLockPrefix+job.name, run.RunID); err != nil {
+			log.Printf("⏰ Failed to release the lock of job %s: %v", job.name, err)
+		}
+	}
+	job.mu.Lock()
+	job.running = false
+	job.mu.Unlock()
+
+	finished := time.Now()
+	run.FinishedAt = finished.UTC().Format(time.RFC3339)
+	run.DurationMS = finished.Sub(started).Milliseconds()
+	run.Status = jobSucceeded
+	if err != nil {
+		run.Status, run.Error = jobFailed, err.Error()
+		log.Printf("⏰ Job %s failed after %s: %v", job.name, finished.Sub(started).Round(time.Millisecond), err)
+	}
+	if err := s.record(ctx, job, *run); err != nil {
+		log.Printf("⏰ Failed to record the run of job %s: %v", job.name, err)
+	}
+	return *run
+}
+
+func (s *jobScheduler) record(ctx context.Context, job *scheduledJob, run JobRun) error {
+	store := s.store(job)
+	record, err := store.get(ctx, job.name)
+	if err != nil {
+		return err
+	}
+	record.LastRun = &run
+	record.Runs++
+	if run.Status == jobFailed {
+		record.LastFailureAt = run.FinishedAt
+		record.ConsecutiveFailures++
+		record.Failures++
+	} else {
+		record.LastSuccessAt = run.FinishedAt
+		record.ConsecutiveFailures = 0
+	}
+	return store.put(ctx, job.name, record)
+}
+
+func newJobRunID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return "RUN-" + hex.EncodeToString(b)
+}
+
+func (s *jobScheduler) status(ctx context.Context, job *scheduledJob) (JobStatus, error) {
+	record, err := s.store(job).get(ctx, job.name)
+	if err != nil {
+		return JobStatus{}, err
+	}
+	status := JobStatus{
+		Name:                job.name,
+		Description:         job.description,
+		Schedule:            job.schedule.spec,
+		Local:               job.local,
+		Enabled:             job.enabled,
+		LastRun:             record.LastRun,
+		LastSuccessAt:       record.LastSuccessAt,
+		LastFailureAt:       record.LastFailureAt,
+		ConsecutiveFailures: record.ConsecutiveFailures,
+		Runs:                record.Runs,
+		Failures:            record.Failures,
+	}
+	job.mu.Lock()
+	status.Running = job.running
+	if job.enabled && !job.nextRun.IsZero() {
+		status.NextRunAt = job.nextRun.UTC().Format(time.RFC3339)
+	}
+	job.mu.Unlock()
+	return status, nil
+}
+
+type redisJobStore struct {
+	redis *redisClient
+}
+
+func (s redisJobStore) get(ctx context.Context, name string) (jobRecord, error) {
+	var record jobRecord
+	reply, err := s.redis.Do(ctx, "HGET", jobStatusKey, name)
+	if errors.Is(err, errRedisNil) {
+		return record, nil
+	}
+	if err != nil {
+		return record, err
+	}
+	raw, _ := reply.([]byte)
+	if len(raw) > 0 {
+		err = json.Unmarshal(raw, &record)
+	}
+	return record, err
+}
+
+func (s redisJobStore) put(ctx context.Context, name string, record jobRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = s.redis.Do(ctx, "HSET", jobStatusKey, name, string(data))
+	return err
+}
+
+// memoryJobStore serves a single gateway instance
+type memoryJobStore struct {
+	mu      sync.Mutex
+	records map[string]jobRecord
+}
+
+func newMemoryJobStore() *memoryJobStore {
+	return &memoryJobStore{records: map[string]jobRecord{}}
+}
+
+func (s *memoryJobStore) get(_ context.Context, name string) (jobRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.records[name], nil
+}
+
+func (s *memoryJobStore) put(_ context.Context, name string, record jobRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[name] = record
+	return nil
+}
+
+func listJobs(c *gin.Context) {
+	jobs := make([]JobStatus, 0, len(scheduler.order))
+	for _, name := range scheduler.order {
+		status, err := scheduler.status(c.Request.Context(), scheduler.jobs[name])
+		if err != nil {
+			respondServiceError(c, "Failed to list jobs", err)
+			return
+		}
+		jobs = append(jobs, status)
+	}
+	respondData(c, http.StatusOK, jobs)
+}
+
+func scheduledJobParam(c *gin.Context) (*scheduledJob, bool) {
+	name, ok := validPathID(c, "name")
+	if !ok {
+		return nil, false
+	}
+	job := scheduler.jobs[name]
+	if job == nil {
+		respondError(c, http.StatusNotFound, errCodeNotFound, "No job with this name")
+		return nil, false
+	}
+	return job, true
+}
+
+func getJob(c *gin.Context) {
+	job, ok := scheduledJobParam(c)
+	if !ok {
+		return
+	}
+	status, err := scheduler.status(c.Request.Context(), job)
+	if err != nil {
+		respondServiceError(c, "Failed to read job", err)
+		return
+	}
+	respondData(c, http.StatusOK, status)
+}
+
+// runJob starts a job now, on this instance, even if it is disabled. It answers once the job has
+// started; its outcome is the job's last run.
+func runJob(c *gin.Context) {
+	job, ok := scheduledJobParam(c)
+	if !ok {
+		return
+	}
+	var req RunJobRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+	setAuditTarget(c, job.name)
+	run, err := scheduler.start(c.Request.Context(), job, "api:"+req.Actor)
+	if errors.Is(err, errJobRunning) {
+		respondError(c, http.StatusConflict, errCodeJobRunning, "The job is already running")
+		return
+	}
+	if err != nil {
+		respondServiceError(c, "Failed to start job", err)
+		return
+	}
+	// The run outlives the request, bounded by the job's timeout
+	go scheduler.finish(context.WithoutCancel(c.Request.Context()), job, run)
+	respondData(c, http.StatusAccepted, run)
+}
@@ -0,0 +1,92 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestJobScheduleNext(t *testing.T) {
+	kolkata, err := time.LoadLocation("Asia/Kolkata")
+	if err != nil {
+		t.Skip("timezone data unavailable")
+	}
+	from := time.Date(2026, 10, 16, 3, 45, 30, 0, kolkata)
+	cases := []struct {
+		spec string
+		want time.Time
+	}{
+		{"30 3 * * *", time.Date(2026, 10, 17, 3, 30, 0, 0, kolkata)},
+		{"*/20 * * * *", time.Date(2026, 10, 16, 4, 0, 0, 0, kolkata)},
+		{"0 9-17/4 * * 1-5", time.Date(2026, 10, 16, 9, 0, 0, 0, kolkata)},
+		// Sunday is 7 as well as 0
+		{"0 6 * * 7", time.Date(2026, 10, 18, 6, 0, 0, 0, kolkata)},
+		// With both day fields restricted, either matches
+		{"0 0 1 * 5", time.Date(2026, 10, 23, 0, 0, 0, 0, kolkata)},
+		{"@monthly", time.Date(2026, 11, 1, 0, 0, 0, 0, kolkata)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, kolkata)},
+		{"@every 15m", from.Truncate(15 * time.Minute).Add(15 * time.Minute)},
+	}
+	for _, tc := range cases {
+		schedule, err := parseJobSchedule(tc.spec)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.spec, err)
+		}
+		if got := schedule.next(from, kolkata); !got.Equal(tc.want) {
+			t.Errorf("%s: expected %s, got %s", tc.spec, tc.want, got.In(kolkata))
+		}
+	}
+
+	for _, spec := range []string{"* * * *", "60 * * * *", "5-1 * * * *", "*/0 * * * *", "0 0 30 2 *", "@every 10ms", "@yearly"} {
+		if _, err := parseJobSchedule(spec); err == nil {
+			t.Errorf("expected %q refused", spec)
+		}
+	}
+}
+
+func TestJobRuns(t *testing.T) {
+	s := &jobScheduler{jobs: map[string]*scheduledJob{}, shared: newMemoryJobStore(), local: newMemoryJobStore(), location: time.UTC, instance: "gw-1"}
+	release := make(chan struct{})
+	fail := true
+	s.register(&scheduledJob{name: jobArchive, schedule: everySchedule(time.Hour), timeout: time.Minute, run: func(ctx context.Context, now time.Time) error {
+		<-release
+		if fail {
+			return errors.New("cold storage unavailable")
+		}
+		return nil
+	}})
+	job := s.jobs[jobArchive]
+	ctx := context.Background()
+
+	run, err := s.start(ctx, job, "api:admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.start(ctx, job, "schedule"); !errors.Is(err, errJobRunning) {
+		t.Fatalf("expected a second run refused while the first is running, got %v", err)
+	}
+	done := make(chan JobRun)
+	go func() { done <- s.finish(ctx, job, run) }()
+	release <- struct{}{}
+	if finished := <-done; finished.Status != jobFailed || finished.Error != "cold storage unavailable" || finished.Trigger != "api:admin" {
+		t.Errorf("expected the failure recorded, got %+v", finished)
+	}
+
+	fail = false
+	run, err = s.start(ctx, job, "schedule")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { done <- s.finish(ctx, job, run) }()
+	release <- struct{}{}
+	<-done
+
+	status, err := s.status(ctx, job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Running || status.Runs != 2 || status.Failures != 1 || status.ConsecutiveFailures != 0 || status.LastRun.Status != jobSucceeded || status.LastFailureAt == "" {
+		t.Errorf("unexpected status %+v", status)
+	}
+}
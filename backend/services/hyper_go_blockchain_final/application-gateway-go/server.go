@@ -0,0 +1,167 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// tlsSettings is the certificate material read on startup and on every SIGHUP
+type tlsSettings struct {
+	certificate *tls.Certificate
+	clientCAs   *x509.CertPool
+}
+
+// tlsReloader serves the most recently loaded certificate and client CA pool to new handshakes
+type tlsReloader struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+	clientAuth   tls.ClientAuthType
+	// clientMapFile maps verified client certificates to callers
+	clientMapFile string
+
+	current atomic.Pointer[tlsSettings]
+}
+
+// reload reads the certificate files and client identities, keeping the previous material if any
+// of them is invalid
+func (r *tlsReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS key pair: %w", err)
+	}
+	settings := &tlsSettings{certificate: &cert}
+
+	if r.clientCAFile != "" {
+		pem, err := os.ReadFile(r.clientCAFile)
+		if err != nil {
+			return fmt.Errorf("failed to read client CA: %w", err)
+		}
+		settings.clientCAs = x509.NewCertPool()
+		if !settings.clientCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in client CA %s", r.clientCAFile)
+		}
+	}
+
+	var rules []clientCertRule
+	if r.clientMapFile != "" {
+		if rules, err = readClientCertRules(r.clientMapFile); err != nil {
+			return err
+		}
+	}
+
+	r.current.Store(settings)
+	if r.clientMapFile != "" {
+		clientCertRules.Store(&rules)
+	}
+	return nil
+}
+
+// tlsConfig builds a server config whose certificate and client CAs follow reloads
+func (r *tlsReloader) tlsConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			settings := r.current.Load()
+			cfg := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*settings.certificate},
+				NextProtos:   []string{"h2", "http/1.1"},
+			}
+			if settings.clientCAs != nil {
+				cfg.ClientCAs = settings.clientCAs
+				cfg.ClientAuth = r.clientAuth
+			}
+			return cfg, nil
+		},
+	}
+}
+
+// watchSIGHUP reloads certificates whenever the process receives SIGHUP
+func (r *tlsReloader) watchSIGHUP(ctx context.Context) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-signals:
+			if err := r.reload(); err != nil {
+				log.Printf("⚠️  TLS reload failed, keeping previous certificate: %v", err)
+				continue
+			}
+			log.Println("🔐 TLS certificate reloaded")
+		}
+	}
+}
+
+// loadTLSReloader loads TLS_CERT_FILE and TLS_KEY_FILE and watches for SIGHUP. It returns nil when
+// TLS is not configured.
+func loadTLSReloader(ctx context.Context) (*tlsReloader, error) {
+	certFile, keyFile := getEnv("TLS_CERT_FILE", ""), getEnv("TLS_KEY_FILE", "")
+	if certFile == "" || keyFile == "" {
+		return nil, nil
+	}
+
+	reloader := &tlsReloader{
+		certFile:     certFile,
+		keyFile:      keyFile,
+		clientCAFile: getEnv("TLS_CLIENT_CA_FILE", ""),
+		clientAuth:   tls.RequireAndVerifyClientCert,
+
+		clientMapFile: getEnv("TLS_CLIENT_IDENTITIES_FILE", ""),
+	}
+	if reloader.clientMapFile != "" && reloader.clientCAFile == "" {
+		return nil, fmt.Errorf("TLS_CLIENT_IDENTITIES_FILE requires TLS_CLIENT_CA_FILE, since only verified certificates are mapped")
+	}
+	if !getEnvBool("TLS_CLIENT_CERT_REQUIRED", true) {
+		reloader.clientAuth = tls.VerifyClientCertIfGiven
+	}
+	if err := reloader.reload(); err != nil {
+		return nil, err
+	}
+	go reloader.watchSIGHUP(ctx)
+
+	if reloader.clientCAFile != "" {
+		log.Printf("🔐 Client certificates verified against %s", reloader.clientCAFile)
+	}
+	if rules := clientCertRules.Load(); rules != nil {
+		log.Printf("🔐 Mapping client certificates to %d callers from %s", len(*rules), reloader.clientMapFile)
+	}
+	return reloader, nil
+}
+
+// runServer serves the API over HTTPS when a TLS reloader is given, otherwise plain HTTP
+func runServer(handler http.Handler, reloader *tlsReloader) error {
+	server := &http.Server{
+		Addr:              getEnv("LISTEN_ADDR", ":8080"),
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	if reloader == nil {
+		log.Printf("🚀 SIH Chaincode API Server starting on %s", server.Addr)
+		return server.ListenAndServe()
+	}
+
+	server.TLSConfig = reloader.tlsConfig()
+	log.Printf("🚀 SIH Chaincode API Server starting on %s (TLS)", server.Addr)
+	return server.ListenAndServeTLS("", "")
+}
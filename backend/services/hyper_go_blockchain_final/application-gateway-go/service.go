@@ -0,0 +1,562 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// malformedDocumentError reports ledger data that could not be decoded
+type malformedDocumentError struct {
+	kind string
+	err  error
+}
+
+func (e *malformedDocumentError) Error() string {
+	return "Failed to parse " + e.kind + " data"
+}
+
+func (e *malformedDocumentError) Unwrap() error {
+	return e.err
+}
+
+// ledgerService implements the chaincode operations shared by the REST and gRPC APIs. Every method
+// validates its input, so transports only have to decode requests and encode results.
+type ledgerService struct{}
+
+var ledger ledgerService
+
+// ledgerTimestamp converts a validated RFC3339 bound into the UTC form the chaincode stores, so
+// string comparison in CouchDB orders correctly
+func ledgerTimestamp(value string) string {
+	if value == "" {
+		return ""
+	}
+	t, _ := time.Parse(time.RFC3339, value)
+	return t.UTC().Format(time.RFC3339)
+}
+
+func decodeDocument[T any](result []byte, kind string) (T, error) {
+	var doc T
+	if err := json.Unmarshal(result, &doc); err != nil {
+		return doc, &malformedDocumentError{kind: kind, err: err}
+	}
+	return doc, nil
+}
+
+// validateMutation combines path ID and body validation into a single error
+func validateMutation(id string, req validatable) error {
+	errs := validateDocumentID("id", id)
+	errs = append(errs, req.Validate()...)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// submitAndInvalidate submits a transaction that changes document id and evicts it from the cache
+func submitAndInvalidate(ctx context.Context, id, name string, args ...string) (*TransactionResult, error) {
+	result, err := submitTransaction(ctx, name, args...)
+	if err != nil {
+		return nil, err
+	}
+	invalidateDocuments(ctx, id)
+	return result, nil
+}
+
+func (ledgerService) readDocument(ctx context.Context, transactionName, id string) ([]byte, error) {
+	if errs := validateDocumentID("id", id); len(errs) > 0 {
+		return nil, errs
+	}
+	return readDocument(ctx, transactionName, id)
+}
+
+// DID operations
+
+func (ledgerService) CreateDID(ctx context.Context, req CreateDIDRequest) (*TransactionResult, error) {
+	if errs := req.Validate(); len(errs) > 0 {
+		return nil, errs
+	}
+	return submitTransaction(ctx, "CreateDID", req.DigitalID, req.ConsentHash, req.ExpiresAt, req.Issuer)
+}
+
+func (s ledgerService) GetDID(ctx context.Context, id string) (DIDDocument, error) {
+	result, err := s.readDocument(ctx, "ReadDID", id)
+	if err != nil {
+		return DIDDocument{}, err
+	}
+	return decodeDocument[DIDDocument](result, "DID")
+}
+
+// VerifyDID reports whether a DID exists, is unexpired and has not been revoked. It reads from the
+// peers, because a stale copy could vouch for a DID that has since been deleted, unless the DID
+// validity cache answers, which it only does while the event stream that keeps it current runs.
+// Deleting a DID revokes it, so a missing DID with a DELETE_DID audit entry is reported as revoked.
+func (ledgerService) VerifyDID(ctx context.Context, id string) (DIDVerification, error) {
+	var v fieldValidator
+	v.digitalID("id", id)
+	if len(v.errors) > 0 {
+		return DIDVerification{}, v.errors
+	}
+
+	now := time.Now().UTC()
+	if verdict, ok := didValidities.verify(id, now); ok {
+		return verdict, nil
+	}
+	generation := didValidities.current()
+	state, err := readDIDValidity(ctx, id)
+	if err != nil {
+		return DIDVerification{}, err
+	}
+	didValidities.fill(generation, id, state, now)
+	return state.verdict(id, now), nil
+}
+
+// readDIDValidity reads the state of a DID from the peers
+func readDIDValidity(ctx context.Context, id string) (didValidity, error) {
+	result, err := evaluateTransaction(ctx, "ReadDID", id)
+	if err != nil {
+		if translateFabricError(err).Code != errCodeNotFound {
+			return didValidity{}, err
+		}
+		return readMissingDID(ctx, id)
+	}
+
+	doc, err := decodeDocument[DIDDocument](result, "DID")
+	if err != nil {
+		return didValidity{}, err
+	}
+	return didValidityOf(doc), nil
+}
+
+func readMissingDID(ctx context.Context, id string) (didValidity, error) {
+	// Any organization may revoke a DID, so the check looks past the caller's tenancy
+	audits, err := listAuditsByTarget(ctx, id)
+	if err != nil {
+		return didValidity{}, err
+	}
+	// Report the latest revocation; audit timestamps are UTC RFC3339, so they sort as strings
+	var state didValidity
+	var revokedAt string
+	for _, audit := range audits {
+		if audit.Action == "DELETE_DID" && audit.Timestamp >= revokedAt {
+			revokedAt = audit.Timestamp
+			state.revoked = true
+			state.txID = audit.TxID
+		}
+	}
+	return state, nil
+}
+
+func (ledgerService) UpdateDID(ctx context.Context, id string, req UpdateDIDRequest) (*TransactionResult, error) {
+	if err := validateMutation(id, req); err != nil {
+		return nil, err
+	}
+	return submitAndInvalidate(ctx, id, "UpdateDID", id, req.ConsentHash, req.ExpiresAt, req.Updater)
+}
+
+func (ledgerService) DeleteDID(ctx context.Context, id string, req DeleteRequest) (*TransactionResult, error) {
+	if err := validateMutation(id, req); err != nil {
+		return nil, err
+	}
+	return submitAndInvalidate(ctx, id, "DeleteDID", id, req.Actor)
+}
+
+// Incident operations
+
+func (ledgerService) CreateIncident(ctx context.Context, req CreateIncidentRequest) (*TransactionResult, error) {
+	if errs := req.Validate(); len(errs) > 0 {
+		return nil, errs
+	}
+	switch {
+	case req.Category != "" || req.Latitude != nil:
+		var result *TransactionResult
+		var err error
+		if req.Latitude != nil {
+			geohash := encodeGeohash(*req.Latitude, *req.Longitude, ledgerGeohashPrecision)
+			result, err = submitTransaction(ctx, "CreateIncidentWithLocation", req.IncidentID, req.IncidentSummaryHash, req.Reporter, req.Severity, req.Category, req.DigitalID, geohash)
+		} else {
+			result, err = submitTransaction(ctx, "CreateIncidentWithDetails", req.IncidentID, req.IncidentSummaryHash, req.Reporter, req.Severity, req.Category, req.DigitalID)
+		}
+		if err == nil && req.Category == "missing_person" && req.DigitalID != "" {
+			go notifyMissingPerson(context.WithoutCancel(ctx), req.IncidentID, req.DigitalID)
+			go openFiledMissingPerson(context.WithoutCancel(ctx), req.IncidentID, req.DigitalID, req.Reporter)
+		}
+		if err == nil && req.Severity == "critical" && req.Latitude != nil && dispatcher != nil {
+			go dispatcher.dispatchIncident(context.WithoutCancel(ctx), req, result.TxID)
+		}
+		if err == nil && erss != nil {
+			erss.queueIncident(ctx, req)
+		}
+		return result, err
+	case req.Severity != "":
+		result, err := submitTransaction(ctx, "CreateIncidentWithSeverity", req.IncidentID, req.IncidentSummaryHash, req.Reporter, req.Severity)
+		if err == nil && erss != nil {
+			erss.queueIncident(ctx, req)
+		}
+		return result, err
+	}
+	return submitTransaction(ctx, "CreateIncident", req.IncidentID, req.IncidentSummaryHash, req.Reporter)
+}
+
+// SearchIncidents runs a filtered, paginated incident query, newest first
+func (ledgerService) SearchIncidents(ctx context.Context, req IncidentSearchRequest) (IncidentPage, error) {
+	if errs := req.Validate(); len(errs) > 0 {
+		return IncidentPage{}, errs
+	}
+	if req.PageSize == 0 {
+		req.PageSize = defaultPageSize
+	}
+	t := tenantFromContext(ctx)
+	if offchain != nil && isDefaultTarget(ctx) {
+		return offchain.SearchIncidents(ctx, req, t)
+	}
+
+	var result []byte
+	var err error
+	if t.all {
+		result, err = evaluateTransaction(ctx, "QueryIncidents",
+			ledgerTimestamp(req.From), ledgerTimestamp(req.To), req.Status, req.Severity, req.Reporter,
+			strconv.Itoa(req.PageSize), req.Bookmark)
+	} else {
+		result, err = evaluateTransaction(ctx, "QueryIncidentsForOrg",
+			ledgerTimestamp(req.From), ledgerTimestamp(req.To), req.Status, req.Severity, req.Reporter,
+			t.org, strconv.FormatBool(t.includesUnowned()), strconv.Itoa(req.PageSize), req.Bookmark)
+	}
+	if err != nil {
+		return IncidentPage{}, err
+	}
+
+	var page struct {
+		Incidents []IncidentDocument `json:"incidents"`
+		Bookmark  string             `json:"bookmark"`
+	}
+	if err := json.Unmarshal(result, &page); err != nil {
+		return IncidentPage{}, &malformedDocumentError{kind: "incident list", err: err}
+	}
+	if page.Incidents == nil {
+		page.Incidents = []IncidentDocument{}
+	}
+
+	response := IncidentPage{Incidents: page.Incidents, Count: len(page.Incidents)}
+	// A short page means the query is exhausted, so there is nothing more to fetch
+	if len(page.Incidents) == req.PageSize {
+		response.Bookmark = page.Bookmark
+	}
+	return response, nil
+}
+
+func (s ledgerService) GetIncident(ctx context.Context, id string) (IncidentDocument, error) {
+	result, err := s.readDocument(ctx, "ReadIncident", id)
+	if err != nil {
+		return IncidentDocument{}, err
+	}
+	incident, err := decodeDocument[IncidentDocument](result, "incident")
+	if err == nil && !tenantFromContext(ctx).reads(incident.OwnerOrg, grantScopeIncident, incident.IncidentID, "") {
+		return IncidentDocument{}, fmt.Errorf("the incident %s does not exist", id)
+	}
+	return incident, err
+}
+
+// ownedIncident reads an incident the caller's organization owns. Writes check with it rather than
+// GetIncident, since an access grant only lets another organization read the incident.
+func (s ledgerService) ownedIncident(ctx context.Context, id string) (IncidentDocument, error) {
+	incident, err := s.GetIncident(ctx, id)
+	if err == nil && !tenantFromContext(ctx).owns(incident.OwnerOrg) {
+		return IncidentDocument{}, fmt.Errorf("the incident %s does not exist", id)
+	}
+	return incident, err
+}
+
+func (ledgerService) UpdateIncident(ctx context.Context, id string, req UpdateIncidentRequest) (*TransactionResult, error) {
+	if err := validateMutation(id, req); err != nil {
+		return nil, err
+	}
+	return submitAndInvalidate(ctx, id, "UpdateIncident", id, req.IncidentSummaryHash, req.Updater)
+}
+
+// UpdateIncidentStatus moves an incident forward through its lifecycle; the chaincode rejects
+// transitions back to an earlier state
+func (ledgerService) UpdateIncidentStatus(ctx context.Context, id string, req UpdateIncidentStatusRequest) (*TransactionResult, error) {
+	if err := validateMutation(id, req); err != nil {
+		return nil, err
+	}
+	return submitAndInvalidate(ctx, id, "UpdateIncidentStatus", id, req.Status, req.Updater)
+}
+
+// MergeIncidents closes duplicate reports of incident id. Every incident must be the caller's
+// organization's, which the chaincode also requires of the duplicates and the primary together.
+func (s ledgerService) MergeIncidents(ctx context.Context, id string, req MergeIncidentsRequest) (*TransactionResult, error) {
+	if err := validateMutation(id, req); err != nil {
+		return nil, err
+	}
+	if slices.Contains(req.DuplicateIDs, id) {
+		var v fieldValidator
+		v.add("duplicateIDs", "must not include the incident they are merged into")
+		return nil, v.errors
+	}
+	for _, incidentID := range append([]string{id}, req.DuplicateIDs...) {
+		if _, err := s.ownedIncident(ctx, incidentID); err != nil {
+			return nil, err
+		}
+	}
+	duplicateIDs, err := json.Marshal(req.DuplicateIDs)
+	if err != nil {
+		return nil, err
+	}
+	result, err := submitTransaction(ctx, "MergeIncidents", id, string(duplicateIDs), req.Actor)
+	if err != nil {
+		return nil, err
+	}
+	invalidateDocuments(ctx, req.DuplicateIDs...)
+	return result, nil
+}
+
+// ListOpenIncidentsBySubject returns the unresolved incidents about a tourist that the caller's
+// organization owns
+func (ledgerService) ListOpenIncidentsBySubject(ctx context.Context, digitalID string) ([]IncidentDocument, error) {
+	var v fieldValidator
+	if v.digitalID("digital_id", digitalID); len(v.errors) > 0 {
+		return nil, v.errors
+	}
+	var incidents []IncidentDocument
+	if offchain != nil && isDefaultTarget(ctx) {
+		var err error
+		if incidents, err = offchain.ListOpenIncidentsBySubject(ctx, digitalID); err != nil {
+			return nil, err
+		}
+	} else {
+		result, err := evaluateTransaction(ctx, "GetOpenIncidentsBySubject", digitalID)
+		if err != nil {
+			return nil, err
+		}
+		if incidents, err = decodeDocument[[]IncidentDocument](result, "incident list"); err != nil {
+			return nil, err
+		}
+	}
+	return filterOwned(tenantFromContext(ctx), incidents, func(i IncidentDocument) string { return i.OwnerOrg }), nil
+}
+
+func (ledgerService) DeleteIncident(ctx context.Context, id string, req DeleteRequest) (*TransactionResult, error) {
+	if err := validateMutation(id, req); err != nil {
+		return nil, err
+	}
+	return submitAndInvalidate(ctx, id, "DeleteIncident", id, req.Actor)
+}
+
+// Evidence operations
+
+func (ledgerService) CreateEvidence(ctx context.Context, req CreateEvidenceRequest) (*TransactionResult, error) {
+	if errs := req.Validate(); len(errs) > 0 {
+		return nil, errs
+	}
+	return submitTransaction(ctx, "CreateEvidence", req.EvidenceID, req.EvidenceHash, req.IncidentID, req.MediaType, req.UploadedBy)
+}
+
+func (s ledgerService) GetEvidence(ctx context.Context, id string) (EvidenceDocument, error) {
+	result, err := s.readDocument(ctx, "ReadEvidence", id)
+	if err != nil {
+		return EvidenceDocument{}, err
+	}
+	evidence, err := decodeDocument[EvidenceDocument](result, "evidence")
+	if err == nil && !tenantFromContext(ctx).reads(evidence.OwnerOrg, grantScopeEvidence, evidence.IncidentID, evidence.EvidenceID) {
+		return EvidenceDocument{}, fmt.Errorf("the evidence %s does not exist", id)
+	}
+	return evidence, err
+}
+
+func (ledgerService) UpdateEvidence(ctx context.Context, id string, req UpdateEvidenceRequest) (*TransactionResult, error) {
+	if err := validateMutation(id, req); err != nil {
+		return nil, err
+	}
+	return submitAndInvalidate(ctx, id, "UpdateEvidence", id, req.EvidenceHash, req.MediaType, req.Updater)
+}
+
+func (ledgerService) DeleteEvidence(ctx context.Context, id string, req DeleteRequest) (*TransactionResult, error) {
+	if err := validateMutation(id, req); err != nil {
+		return nil, err
+	}
+	return submitAndInvalidate(ctx, id, "DeleteEvidence", id, req.Actor)
+}
+
+// ListEvidenceByIncident returns the incident's evidence matching req, in the order it asks for
+func (ledgerService) ListEvidenceByIncident(ctx context.Context, incidentID string, req EvidenceListRequest) ([]EvidenceDocument, error) {
+	errs := validateDocumentID("incidentId", incidentID)
+	if errs = append(errs, req.Validate()...); len(errs) > 0 {
+		return nil, errs
+	}
+	var evidence []EvidenceDocument
+	var err error
+	hot := offchain != nil && isDefaultTarget(ctx)
+	if hot {
+		// An archived incident's evidence has left the index
+		var archived bool
+		if archived, err = offchain.archived(ctx, incidentID); err != nil {
+			return nil, err
+		}
+		hot = !archived
+	}
+	if hot {
+		evidence, err = offchain.ListEvidenceByIncident(ctx, incidentID, req)
+	} else {
+		evidence, err = evidenceByIncidentFromLedger(ctx, incidentID, req)
+	}
+	if err != nil {
+		return nil, err
+	}
+	t := tenantFromContext(ctx)
+	readable := make([]EvidenceDocument, 0, len(evidence))
+	for _, e := range evidence {
+		if t.reads(e.OwnerOrg, grantScopeEvidence, e.IncidentID, e.EvidenceID) {
+			readable = append(readable, e)
+		}
+	}
+	return readable, nil
+}
+
+// evidenceByIncidentFromLedger bypasses the off-chain index for callers that must see committed state
+func evidenceByIncidentFromLedger(ctx context.Context, incidentID string, req EvidenceListRequest) ([]EvidenceDocument, error) {
+	result, err := evaluateTransaction(ctx, "QueryEvidenceByIncident", incidentID, req.MediaType, req.UploadedBy, req.From, req.To, req.Sort)
+	if err != nil {
+		return nil, err
+	}
+	return decodeDocument[[]EvidenceDocument](result, "evidence list")
+}
+
+// Audit operations
+
+// ListAuditsByTarget returns the audit entries for a document that were submitted by the caller's
+// organization
+func (ledgerService) ListAuditsByTarget(ctx context.Context, targetID string) ([]AuditDocument, error) {
+	audits, err := listAuditsByTarget(ctx, targetID)
+	if err != nil {
+		return nil, err
+	}
+	return filterOwned(tenantFromContext(ctx), audits, auditOwner), nil
+}
+
+// listAuditsByTarget returns every audit entry for a document whichever organization submitted it
+func listAuditsByTarget(ctx context.Context, targetID string) ([]AuditDocument, error) {
+	if errs := validateDocumentID("targetId", targetID); len(errs) > 0 {
+		return nil, errs
+	}
+	if offchain != nil && isDefaultTarget(ctx) {
+		return offchain.ListAuditsByTarget(ctx, targetID)
+	}
+	return auditsByTargetFromLedger(ctx, targetID)
+}
+
+// auditsByTargetFromLedger bypasses the off-chain index for callers that must see committed state
+func auditsByTargetFromLedger(ctx context.Context, targetID string) ([]AuditDocument, error) {
+	result, err := evaluateTransaction(ctx, "GetAuditsByTarget", targetID)
+	if err != nil {
+		return nil, err
+	}
+	return decodeDocument[[]AuditDocument](result, "audit list")
+}
+
+// ExportDocuments reads a page of every document of docType as stored, keeping only those the
+// tenant owns. A short page from the ledger means there is nothing more to fetch.
+func (ledgerService) ExportDocuments(ctx context.Context, docType, bookmark string) (DocumentPage, error) {
+	result, err := evaluateTransaction(ctx, "ExportDocuments", docType, strconv.Itoa(exportPageSize), bookmark)
+	if err != nil {
+		return DocumentPage{}, err
+	}
+	var page struct {
+		Documents []string `json:"documents"`
+		Bookmark  string   `json:"bookmark"`
+	}
+	if err := json.Unmarshal(result, &page); err != nil {
+		return DocumentPage{}, &malformedDocumentError{kind: docType + " export", err: err}
+	}
+
+	t := tenantFromContext(ctx)
+	owner := documentOwners[docType]
+	response := DocumentPage{Documents: make([]json.RawMessage, 0, len(page.Documents))}
+	for _, doc := range page.Documents {
+		if owner == nil || t.owns(owner([]byte(doc))) {
+			response.Documents = append(response.Documents, json.RawMessage(doc))
+		}
+	}
+	if len(page.Documents) == exportPageSize {
+		response.Bookmark = page.Bookmark
+	}
+	return response, nil
+}
+
+// SearchAudits runs a paginated audit query by actor, action and period, newest first
+func (ledgerService) SearchAudits(ctx context.Context, req AuditSearchRequest) (AuditPage, error) {
+	if errs := req.Validate(); len(errs) > 0 {
+		return AuditPage{}, errs
+	}
+	if req.PageSize == 0 {
+		req.PageSize = defaultPageSize
+	}
+	t := tenantFromContext(ctx)
+	if offchain != nil && isDefaultTarget(ctx) {
+		return offchain.SearchAudits(ctx, req, t)
+	}
+
+	var result []byte
+	var err error
+	if t.all {
+		result, err = evaluateTransaction(ctx, "QueryAudits",
+			req.Actor, req.Action, ledgerTimestamp(req.From), ledgerTimestamp(req.To),
+			strconv.Itoa(req.PageSize), req.Bookmark)
+	} else {
+		result, err = evaluateTransaction(ctx, "QueryAuditsForOrg",
+			req.Actor, req.Action, ledgerTimestamp(req.From), ledgerTimestamp(req.To),
+			t.org, strconv.FormatBool(t.includesUnowned()), strconv.Itoa(req.PageSize), req.Bookmark)
+	}
+	if err != nil {
+		return AuditPage{}, err
+	}
+
+	var page struct {
+		Audits   []AuditDocument `json:"audits"`
+		Bookmark string          `json:"bookmark"`
+	}
+	if err := json.Unmarshal(result, &page); err != nil {
+		return AuditPage{}, &malformedDocumentError{kind: "audit list", err: err}
+	}
+	if page.Audits == nil {
+		page.Audits = []AuditDocument{}
+	}
+
+	response := AuditPage{Audits: page.Audits, Count: len(page.Audits)}
+	if len(page.Audits) == req.PageSize {
+		response.Bookmark = page.Bookmark
+	}
+	return response, nil
+}
+
+// respondServiceError writes the REST response for an error returned by the service layer
+func respondServiceError(c *gin.Context, action string, err error) {
+	var validationErrs ValidationErrors
+	var malformed *malformedDocumentError
+	var consentRequired *consentRequiredError
+	switch {
+	case errors.As(err, &validationErrs):
+		respondValidationErrors(c, validationErrs)
+	case errors.As(err, &malformed):
+		logWithContext(c.Request.Context(), "%s: %v", malformed.Error(), malformed.err)
+		respondError(c, http.StatusInternalServerError, errCodeInternal, malformed.Error())
+	case errors.As(err, &consentRequired):
+		respondError(c, http.StatusForbidden, errCodeConsentRequired, fmt.Sprintf("The tourist has not consented to %s", consentRequired.purpose))
+	default:
+		respondFabricError(c, action, err)
+	}
+}
@@ -0,0 +1,546 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	shareKeyPrefix    = "sih:share:"
+	shareDIDKeyPrefix = "sih:share:did:"
+	shareViewRoute    = "/share/:token"
+	// shareRetention keeps a share's record, and its view count, for a while after it expires
+	shareRetention  = 7 * 24 * time.Hour
+	maxSharesPerDID = 20
+
+	errCodeSharesDisabled = "SHARES_DISABLED"
+	errCodeShareInvalid   = "SHARE_INVALID"
+)
+
+var (
+	shareRelationships = []string{"parent", "spouse", "child", "sibling", "relative", "friend", "guardian"}
+
+	errShareInvalid = errors.New("the share link is invalid, expired or revoked")
+)
+
+// CreateShareRequest lets a family member follow a tourist until ExpiresAt
+type CreateShareRequest struct {
+	DigitalID     string `json:"digitalID" binding:"required"`
+	RecipientName string `json:"recipientName" binding:"required"`
+	Relationship  string `json:"relationship" binding:"required"`
+	ExpiresAt     string `json:"expiresAt" binding:"required"`
+	Actor         string `json:"actor" binding:"required"`
+}
+
+func (r CreateShareRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.digitalID("digitalID", r.DigitalID)
+	if name := strings.TrimSpace(r.RecipientName); name == "" || len(name) > 100 {
+		v.add("recipientName", "must be 1 to 100 characters")
+	}
+	v.oneOf("relationship", r.Relationship, shareRelationships)
+	v.future("expiresAt", r.ExpiresAt)
+	v.identifier("actor", r.Actor)
+	return v.errors
+}
+
+// ShareListRequest names the tourist whose shares to list
+type ShareListRequest struct {
+	DigitalID string `form:"digital_id"`
+}
+
+func (r ShareListRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.digitalID("digital_id", r.DigitalID)
+	return v.errors
+}
+
+// FamilyShare is a time-boxed link to a tourist's coarse location and safety status. Only the hash
+// of the link's secret is kept.
+type FamilyShare struct {
+	ShareID       string `json:"share_id"`
+	DigitalID     string `json:"digital_id"`
+	RecipientName string `json:"recipient_name"`
+	Relationship  string `json:"relationship"`
+	CreatedBy     string `json:"created_by"`
+	CreatedAt     string `json:"created_at"`
+	ExpiresAt     string `json:"expires_at"`
+	RevokedAt     string `json:"revoked_at,omitempty"`
+	RevokedBy     string `json:"revoked_by,omitempty"`
+	Views         int    `json:"views"`
+	LastViewedAt  string `json:"last_viewed_at,omitempty"`
+	SecretHash    string `json:"secret_hash,omitempty"`
+}
+
+// public strips the secret hash from responses
+func (s FamilyShare) public() FamilyShare {
+	s.SecretHash = ""
+	return s
+}
+
+// activeAt reports whether the link works at t
+func (s FamilyShare) activeAt(t time.Time) bool {
+	expires, _ := time.Parse(time.RFC3339, s.ExpiresAt)
+	return s.RevokedAt == "" && t.Before(expires)
+}
+
+// CreatedShare is a new share with its link, which is shown only once
+type CreatedShare struct {
+	FamilyShare
+	Token string `json:"token"`
+	URL   string `json:"url"`
+}
+
+// SharedStatus is what a family member sees. The location is the centre of a geohash cell, never
+// the exact fix.
+type SharedStatus struct {
+	RecipientName string  `json:"recipient_name"`
+	ExpiresAt     string  `json:"expires_at"`
+	LocationKnown bool    `json:"location_known"`
+	Latitude      float64 `json:"latitude,omitempty"`
+	Longitude     float64 `json:"longitude,omitempty"`
+	// PrecisionKm is the width of the cell the tourist is somewhere in
+	PrecisionKm float64 `json:"precision_km,omitempty"`
+	LocatedAt   string  `json:"located_at,omitempty"`
+	SafetyLevel string  `json:"safety_level,omitempty"`
+	SafetyScore *int    `json:"safety_score,omitempty"`
+	GeneratedAt string  `json:"generated_at"`
+}
+
+// shareStore keeps shares until shareRetention after they expire
+type shareStore interface {
+	get(ctx context.Context, shareID string) (*FamilyShare, error)
+	put(ctx context.Context, share FamilyShare) error
+	// list returns the IDs of a tourist's shares, including expired ones still retained
+	list(ctx context.Context, digitalID string) ([]string, error)
+}
+
+// shareService issues share links and resolves them for family members
+type shareService struct {
+	store     shareStore
+	maxTTL    time.Duration
+	precision int
+	baseURL   string
+}
+
+var shares *shareService
+
+// initShares runs after initDocumentCache and initLocation
+func initShares() {
+	if !getEnvBool("SHARES_ENABLED", false) {
+		log.Println("👪 SHARES_ENABLED not set, family tracking shares disabled")
+		return
+	}
+	var store shareStore = newMemoryShareStore()
+	backend := "memory"
+	if documentCache != nil {
+		store, backend = redisShareStore{documentCache}, "Redis"
+	}
+	shares = &shareService{
+		store:     store,
+		maxTTL:    getEnvDuration("SHARE_MAX_TTL", 72*time.Hour),
+		precision: getEnvInt("SHARE_LOCATION_PRECISION", 5),
+		baseURL:   strings.TrimRight(getEnv("SHARE_BASE_URL", ""), "/"),
+	}
+	if shares.maxTTL <= 0 {
+		panic(fmt.Errorf("SHARE_MAX_TTL must be positive"))
+	}
+	if shares.precision < 1 || shares.precision > 7 {
+		panic(fmt.Errorf("SHARE_LOCATION_PRECISION must be between 1 and 7"))
+	}
+	log.Printf("👪 Family tracking shares enabled for up to %s at geohash precision %d, stored in %s", shares.maxTTL, shares.precision, backend)
+}
+
+// splitShareToken separates a token into its share ID and secret
+func splitShareToken(token string) (string, string, bool) {
+	shareID, secret, ok := strings.Cut(token, ".")
+	if !ok || !strings.HasPrefix(shareID, "SHR-") || !identifierRegex.MatchString(shareID) || len(secret) != 43 {
+		return "", "", false
+	}
+	return shareID, secret, true
+}
+
+func shareSecretHash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// create issues a link for a tourist who has consented to family sharing. The link cannot outlive
+// SHARE_MAX_TTL or the consent.
+func (s *shareService) create(ctx context.Context, req CreateShareRequest) (*CreatedShare, error) {
+	if errs := req.Validate(); len(errs) > 0 {
+		return nil, errs
+	}
+	now := time.Now().UTC()
+	expires, _ := time.Parse(time.RFC3339, req.ExpiresAt)
+	if expires.Sub(now) > s.maxTTL {
+		return nil, ValidationErrors{{Field: "expiresAt", Message: fmt.Sprintf("must be within %s", s.maxTTL)}}
+	}
+	consent, err := ledger.GetConsent(ctx, req.DigitalID, purposeFamilySharing)
+	if err != nil && translateFabricError(err).Code != errCodeNotFound {
+		return nil, err
+	}
+	if err != nil || !consent.active(now) {
+		return nil, &consentRequiredError{digitalID: req.DigitalID, purpose: purposeFamilySharing}
+	}
+	if consentExpires, _ := time.Parse(time.RFC3339, consent.ExpiresAt); expires.After(consentExpires) {
+		expires = consentExpires
+	}
+	existing, err := s.list(ctx, ShareListRequest{DigitalID: req.DigitalID})
+	if err != nil {
+		return nil, err
+	}
+	active := 0
+	for _, share := range existing {
+		if share.activeAt(now) {
+			active++
+		}
+	}
+	if active >= maxSharesPerDID {
+		return nil, ValidationErrors{{Field: "digitalID", Message: fmt.Sprintf("already has %d active shares; revoke one first", maxSharesPerDID)}}
+	}
+
+	id, secret := make([]byte, 8), make([]byte, 32)
+	rand.Read(id)
+	rand.Read(secret)
+	share := FamilyShare{
+		ShareID:       "SHR-" + hex.EncodeToString(id),
+		DigitalID:     req.DigitalID,
+		RecipientName: strings.TrimSpace(req.RecipientName),
+		Relationship:  req.Relationship,
+		CreatedBy:     req.Actor,
+		CreatedAt:     now.Format(time.RFC3339),
+		ExpiresAt:     expires.UTC().Format(time.RFC3339),
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(secret)
+	share.SecretHash = shareSecretHash(encoded)
+	if err := s.store.put(ctx, share); err != nil {
+		return nil, err
+	}
+	token := share.ShareID + "." + encoded
+	return &CreatedShare{FamilyShare: share.public(), Token: token, URL: s.baseURL + "/share/" + token}, nil
+}
+
+// revoke ends a share early
+func (s *shareService) revoke(ctx context.Context, shareID string, req DeleteRequest) (*FamilyShare, error) {
+	if err := validateMutation(shareID, req); err != nil {
+		return nil, err
+	}
+	share, err := s.store.get(ctx, shareID)
+	if err != nil || share == nil {
+		return nil, err
+	}
+	if share.RevokedAt == "" {
+		share.RevokedAt, share.RevokedBy = time.Now().UTC().Format(time.RFC3339), req.Actor
+		if err := s.store.put(ctx, *share); err != nil {
+			return nil, err
+		}
+	}
+	public := share.public()
+	return &public, nil
+}
+
+// view resolves a token to the tourist's coarse status. Every failure is the same error, so a
+// token cannot be probed. Consent is checked on every view, so withdrawing it ends all links.
+func (s *shareService) view(ctx context.Context, token string) (*FamilyShare, *SharedStatus, error) {
+	shareID, secret, ok := splitShareToken(token)
+	if !ok {
+		return nil, nil, errShareInvalid
+	}
+	share, err := s.store.get(ctx, shareID)
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now().UTC()
+	if share == nil || subtle.ConstantTimeCompare([]byte(shareSecretHash(secret)), []byte(share.SecretHash)) != 1 || !share.activeAt(now) {
+		return share, nil, errShareInvalid
+	}
+	granted, err := ledger.consentGranted(ctx, share.DigitalID, purposeFamilySharing)
+	if err != nil {
+		return share, nil, err
+	}
+	if !granted {
+		return share, nil, errShareInvalid
+	}
+
+	status := &SharedStatus{RecipientName: share.RecipientName, ExpiresAt: share.ExpiresAt, GeneratedAt: now.Format(time.RFC3339)}
+	if locations != nil {
+		live, err := locations.store.latest(ctx, share.DigitalID)
+		if err != nil {
+			return share, nil, err
+		}
+		if live != nil {
+			cell, _ := geohashBounds(encodeGeohash(live.Latitude, live.Longitude, s.precision))
+			lat, lng := (cell.minLat+cell.maxLat)/2, (cell.minLng+cell.maxLng)/2
+			status.LocationKnown, status.LocatedAt = true, live.RecordedAt
+			status.Latitude, status.Longitude = math.Round(lat*1e4)/1e4, math.Round(lng*1e4)/1e4
+			status.PrecisionKm = math.Round(haversineKm(lat, cell.minLng, lat, cell.maxLng)*10) / 10
+		}
+	}
+	if safety != nil {
+		if score, err := safety.current(ctx, share.DigitalID); err != nil {
+			logWithContext(ctx, "Share %s without a safety score: %v", share.ShareID, err)
+		} else if score != nil {
+			status.SafetyLevel, status.SafetyScore = score.Level, &score.Score
+		}
+	}
+
+	share.Views++
+	share.LastViewedAt = now.Format(time.RFC3339)
+	if err := s.store.put(ctx, *share); err != nil {
+		logWithContext(ctx, "Failed to count a view of share %s: %v", share.ShareID, err)
+	}
+	return share, status, nil
+}
+
+func (s *shareService) list(ctx context.Context, req ShareListRequest) ([]FamilyShare, error) {
+	if errs := req.Validate(); len(errs) > 0 {
+		return nil, errs
+	}
+	ids, err := s.store.list(ctx, req.DigitalID)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]FamilyShare, 0, len(ids))
+	for _, id := range ids {
+		share, err := s.store.get(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if share != nil {
+			list = append(list, share.public())
+		}
+	}
+	return list, nil
+}
+
+func sharesDisabled(c *gin.Context) bool {
+	if shares == nil {
+		respondError(c, http.StatusServiceUnavailable, errCodeSharesDisabled, "Family tracking shares are not enabled")
+		return true
+	}
+	return false
+}
+
+func createShare(c *gin.Context) {
+	if sharesDisabled(c) {
+		return
+	}
+	var req CreateShareRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+	setAuditTarget(c, req.DigitalID)
+	if !passkeyAsserted(c, req.DigitalID, passkeyActionShareCreate) {
+		return
+	}
+
+	created, err := shares.create(c.Request.Context(), req)
+	if err != nil {
+		respondServiceError(c, "Failed to create share", err)
+		return
+	}
+	respondData(c, http.StatusCreated, created)
+}
+
+func listShares(c *gin.Context) {
+	if sharesDisabled(c) {
+		return
+	}
+	var req ShareListRequest
+	if !bindQuery(c, &req) {
+		return
+	}
+
+	list, err := shares.list(c.Request.Context(), req)
+	if err != nil {
+		respondServiceError(c, "Failed to list shares", err)
+		return
+	}
+	respondData(c, http.StatusOK, list)
+}
+
+func revokeShare(c *gin.Context) {
+	if sharesDisabled(c) {
+		return
+	}
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	var req DeleteRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+
+	share, err := shares.revoke(c.Request.Context(), id, req)
+	if err != nil {
+		respondServiceError(c, "Failed to revoke share", err)
+		return
+	}
+	if share == nil {
+		respondError(c, http.StatusNotFound, errCodeNotFound, "No such share")
+		return
+	}
+	respondData(c, http.StatusOK, share)
+}
+
+// viewShare serves a share link to a family member. The token is the credential, so the route sits
+// outside the API; each view, successful or not, is audited on the ledger like an API mutation.
+func viewShare(c *gin.Context) {
+	if sharesDisabled(c) {
+		return
+	}
+	ctx := c.Request.Context()
+	receivedAt := time.Now().UTC()
+	share, status, err := shares.view(ctx, c.Param("token"))
+
+	code := http.StatusOK
+	if errors.Is(err, errShareInvalid) {
+		code = http.StatusNotFound
+	} else if err != nil {
+		logWithContext(ctx, "Failed to resolve a share link: %v", err)
+		code = http.StatusServiceUnavailable
+	}
+	if apiAudits != nil {
+		// Without a share the actor is the address setTrustedProxies resolves, which callers cannot forge
+		target, actor := "", "ip:"+c.ClientIP()
+		if share != nil {
+			target, actor = share.DigitalID, "share:"+share.ShareID
+		}
+		sum := sha256.Sum256([]byte(http.MethodGet + " " + shareViewRoute + " " + target + " " + receivedAt.Format(time.RFC3339Nano)))
+		apiAudits.record(ctx, APIAuditEntry{
+			RequestID: newRequestID(ctx), Actor: actor, Method: http.MethodGet, Route: shareViewRoute, TargetID: target,
+			RequestHash: hex.EncodeToString(sum[:]), Status: strconv.Itoa(code), ReceivedAt: receivedAt.Format(time.RFC3339),
+		})
+	}
+
+	c.Header("Cache-Control", "no-store")
+	switch code {
+	case http.StatusOK:
+		respondData(c, code, status)
+	case http.StatusServiceUnavailable:
+		respondError(c, code, errCodeUnavailable, "The tourist's status cannot be read right now")
+	default:
+		respondError(c, code, errCodeShareInvalid, "This share link is invalid, expired or revoked")
+	}
+}
+
+// redisShareStore keeps each share under its ID and a sorted set of a tourist's share IDs scored by
+// expiry
+type redisShareStore struct {
+	redis *redisClient
+}
+
+func (s redisShareStore) get(ctx context.Context, shareID string) (*FamilyShare, error) {
+	data, err := s.redis.Get(ctx, shareKeyPrefix+shareID)
+	if errors.Is(err, errRedisNil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var share FamilyShare
+	if err := json.Unmarshal(data, &share); err != nil {
+		return nil, err
+	}
+	return &share, nil
+}
+
+func (s redisShareStore) put(ctx context.Context, share FamilyShare) error {
+	data, err := json.Marshal(share)
+	if err != nil {
+		return err
+	}
+	expires, _ := time.Parse(time.RFC3339, share.ExpiresAt)
+	if err := s.redis.Set(ctx, shareKeyPrefix+share.ShareID, data, time.Until(expires)+shareRetention); err != nil {
+		return err
+	}
+	_, err = s.redis.Do(ctx, "ZADD", shareDIDKeyPrefix+share.DigitalID, strconv.FormatInt(expires.Add(shareRetention).UnixMilli(), 10), share.ShareID)
+	return err
+}
+
+func (s redisShareStore) list(ctx context.Context, digitalID string) ([]string, error) {
+	key := shareDIDKeyPrefix + digitalID
+	if _, err := s.redis.Do(ctx, "ZREMRANGEBYSCORE", key, "-inf", strconv.FormatInt(time.Now().UnixMilli(), 10)); err != nil {
+		return nil, err
+	}
+	reply, err := s.redis.Do(ctx, "ZRANGE", key, "0", "-1")
+	if err != nil {
+		return nil, err
+	}
+	members, _ := reply.([]interface{})
+	ids := make([]string, 0, len(members))
+	for _, member := range members {
+		raw, _ := member.([]byte)
+		ids = append(ids, string(raw))
+	}
+	return ids, nil
+}
+
+// memoryShareStore serves a single gateway instance
+type memoryShareStore struct {
+	mu     sync.Mutex
+	shares map[string]FamilyShare
+}
+
+func newMemoryShareStore() *memoryShareStore {
+	return &memoryShareStore{shares: map[string]FamilyShare{}}
+}
+
+func (s *memoryShareStore) get(_ context.Context, shareID string) (*FamilyShare, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	share, ok := s.shares[shareID]
+	if !ok {
+		return nil, nil
+	}
+	return &share, nil
+}
+
+func (s *memoryShareStore) put(_ context.Context, share FamilyShare) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.shares[share.ShareID] = share
+	return nil
+}
+
+func (s *memoryShareStore) list(_ context.Context, digitalID string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ids []string
+	for id, share := range s.shares {
+		if expires, _ := time.Parse(time.RFC3339, share.ExpiresAt); time.Since(expires) > shareRetention {
+			delete(s.shares, id)
+			continue
+		}
+		if share.DigitalID == digitalID {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
@@ -0,0 +1,76 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestFamilyShareLinks(t *testing.T) {
+	ctx := context.Background()
+	s := &shareService{store: newMemoryShareStore(), maxTTL: 72 * time.Hour, precision: 5}
+
+	req := CreateShareRequest{DigitalID: "did:sih:tourist1", RecipientName: "Ravi", Relationship: "parent", ExpiresAt: time.Now().Add(96 * time.Hour).UTC().Format(time.RFC3339), Actor: "tourist_app"}
+	if _, err := s.create(ctx, req); err == nil || !strings.Contains(err.Error(), "expiresAt") {
+		t.Fatalf("expected a link beyond SHARE_MAX_TTL to be refused, got %v", err)
+	}
+	if errs := (CreateShareRequest{DigitalID: "did:sih:tourist1", RecipientName: " ", Relationship: "neighbour", ExpiresAt: req.ExpiresAt, Actor: "tourist_app"}).Validate(); len(errs) != 2 {
+		t.Errorf("expected the name and relationship to be rejected, got %v", errs)
+	}
+
+	secret := strings.Repeat("a", 43)
+	share := FamilyShare{
+		ShareID: "SHR-0011223344556677", DigitalID: "did:sih:tourist1", RecipientName: "Ravi", Relationship: "parent",
+		CreatedAt: time.Now().UTC().Format(time.RFC3339), ExpiresAt: time.Now().Add(time.Hour).UTC().Format(time.RFC3339), SecretHash: shareSecretHash(secret),
+	}
+	s.store.put(ctx, share)
+	if id, got, ok := splitShareToken(share.ShareID + "." + secret); !ok || id != share.ShareID || got != secret {
+		t.Fatalf("expected the token to split, got %s %s", id, got)
+	}
+	for _, token := range []string{"", "SHR-0011223344556677", share.ShareID + "." + strings.Repeat("b", 43), "SHR-ffff." + secret} {
+		if _, _, err := s.view(ctx, token); !errors.Is(err, errShareInvalid) {
+			t.Errorf("expected %q to be refused, got %v", token, err)
+		}
+	}
+
+	revoked, err := s.revoke(ctx, share.ShareID, DeleteRequest{Actor: "tourist_app"})
+	if err != nil || revoked.RevokedAt == "" || revoked.SecretHash != "" {
+		t.Fatalf("expected the share revoked without its secret hash, got %+v, %v", revoked, err)
+	}
+	if _, _, err := s.view(ctx, share.ShareID+"."+secret); !errors.Is(err, errShareInvalid) {
+		t.Errorf("expected a revoked link to be refused, got %v", err)
+	}
+	if list, _ := s.list(ctx, ShareListRequest{DigitalID: "did:sih:tourist1"}); len(list) != 1 || list[0].activeAt(time.Now()) {
+		t.Errorf("expected the revoked share listed as inactive, got %+v", list)
+	}
+}
+
+func TestShareViewAuditsResolvedAddress(t *testing.T) {
+	shares = &shareService{store: newMemoryShareStore(), maxTTL: 72 * time.Hour, precision: 5}
+	apiAudits = newAPIAuditor(10, 0, 10)
+	defer func() { shares, apiAudits = nil, nil }()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	setTrustedProxies(r)
+	r.GET(shareViewRoute, viewShare)
+	req := httptest.NewRequest(http.MethodGet, "/share/SHR-ffff."+strings.Repeat("a", 43), nil)
+	req.RemoteAddr = "203.0.113.9:40000"
+	req.Header.Set("X-Forwarded-For", "198.51.100.7")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	batch := apiAudits.batches[apiAuditBatchKey{}]
+	if w.Code != http.StatusNotFound || batch == nil || len(batch.entries) != 1 {
+		t.Fatalf("expected the failed view audited, got %d and %+v", w.Code, apiAudits.batches)
+	}
+	if actor := batch.entries[0].Actor; actor != "ip:203.0.113.9" {
+		t.Errorf("expected the connection's address audited, not the forwarded one, got %q", actor)
+	}
+}
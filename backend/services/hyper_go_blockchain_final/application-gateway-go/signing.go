@@ -0,0 +1,505 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	deviceIDHeader        = "X-Device-ID"
+	deviceSignatureHeader = "X-Signature"
+	signatureTimeHeader   = "X-Signature-Timestamp"
+	signatureNonceHeader  = "X-Signature-Nonce"
+
+	deviceKeysKey      = "sih:device:keys"
+	signingNoncePrefix = "sih:nonce:"
+	signedDeviceKey    = "signed_device"
+
+	errCodeSignatureRequired  = "SIGNATURE_REQUIRED"
+	errCodeSignatureInvalid   = "SIGNATURE_INVALID"
+	errCodeSignatureExpired   = "SIGNATURE_EXPIRED"
+	errCodeRequestReplayed    = "REQUEST_REPLAYED"
+	errCodeDeviceKeyExists    = "DEVICE_KEY_EXISTS"
+	errCodeSigningDisabled    = "SIGNING_DISABLED"
+	errCodeSigningUnavailable = "SIGNING_UNAVAILABLE"
+
+	// Signed bodies larger than this are spooled to disk while they are hashed
+	maxSignedBodyMemory = 1 << 20
+)
+
+// Device key algorithms
+const (
+	algECDSAP256 = "ecdsa-p256-sha256"
+	algEd25519   = "ed25519"
+)
+
+var signingNonce = regexp.MustCompile(`^[A-Za-z0-9_-]{16,128}$`)
+
+// RegisterDeviceKeyRequest enrols the public half of a key generated in the device's keystore
+type RegisterDeviceKeyRequest struct {
+	DeviceID string `json:"device_id" binding:"required"`
+	// PublicKey is a base64 DER SubjectPublicKeyInfo holding an ECDSA P-256 or Ed25519 key
+	PublicKey string `json:"public_key" binding:"required"`
+	DigitalID string `json:"digital_id"`
+}
+
+func (r RegisterDeviceKeyRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.identifier("device_id", r.DeviceID)
+	if _, _, err := parseDevicePublicKey(r.PublicKey); err != nil {
+		v.add("public_key", "must be a base64 DER ECDSA P-256 or Ed25519 public key")
+	}
+	if r.DigitalID != "" {
+		v.digitalID("digital_id", r.DigitalID)
+	}
+	return v.errors
+}
+
+// DeviceKey is an enrolled device public key
+type DeviceKey struct {
+	DeviceID     string `json:"device_id"`
+	DigitalID    string `json:"digital_id,omitempty"`
+	Algorithm    string `json:"algorithm"`
+	PublicKey    string `json:"public_key"`
+	RegisteredAt string `json:"registered_at"`
+	// Org is the organization that enrolled the device; only its callers can sign with it
+	Org string `json:"org,omitempty"`
+}
+
+// deviceKeyStore keeps device keys in Redis when the document cache is configured, so every
+// gateway instance verifies against the same keys, and in memory otherwise
+type deviceKeyStore interface {
+	get(ctx context.Context, deviceID string) (DeviceKey, error)
+	save(ctx context.Context, key DeviceKey) error
+	remove(ctx context.Context, deviceID string) error
+}
+
+// requestSigning verifies device signatures and remembers the nonces of accepted requests
+type requestSigning struct {
+	keys    deviceKeyStore
+	nonces  idempotencyStore
+	maxSkew time.Duration
+	maxBody int64
+	// routes require a signature, as "METHOD /api/v1/path" keys; other routes verify one if sent
+	routes map[string]bool
+}
+
+var signing *requestSigning
+
+// initRequestSigning enables device request signing when REQUEST_SIGNING_ENABLED is set. Runs after
+// initDocumentCache so keys and nonces can be shared through Redis.
+func initRequestSigning() {
+	if !getEnvBool("REQUEST_SIGNING_ENABLED", false) {
+		log.Println("✍️ Request signing disabled")
+		return
+	}
+
+	routes := map[string]bool{}
+	for _, route := range splitList(getEnv("REQUEST_SIGNING_ROUTES", "POST /sos,POST /evidence/upload")) {
+		method, path, ok := strings.Cut(route, " ")
+		if !ok || !strings.HasPrefix(path, "/") {
+			panic(fmt.Errorf("REQUEST_SIGNING_ROUTES entries must look like \"POST /sos\", got %q", route))
+		}
+		routes[strings.ToUpper(method)+" /api/v1"+path] = true
+	}
+
+	var keys deviceKeyStore = newMemoryDeviceKeyStore()
+	var nonces idempotencyStore = newMemoryIdempotencyStore()
+	backend := "memory"
+	if documentCache != nil {
+		keys, nonces, backend = redisDeviceKeyStore{documentCache}, redisIdempotencyStore{documentCache}, "Redis"
+	}
+	signing = &requestSigning{
+		keys:    keys,
+		nonces:  nonces,
+		maxSkew: getEnvDuration("REQUEST_SIGNING_MAX_SKEW", 5*time.Minute),
+		maxBody: int64(getEnvInt("REQUEST_SIGNING_MAX_BODY_BYTES", 128<<20)),
+		routes:  routes,
+	}
+	log.Printf("✍️ Requiring device signatures on %d routes, keys and nonces kept in %s", len(routes), backend)
+}
+
+// signatureMiddleware rejects requests to signed routes unless they carry a fresh, unused device
+// signature over the method, URI, timestamp, nonce and body. Signatures sent to other routes are
+// verified too, so a device can prove itself wherever it wants to.
+func signatureMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if signing == nil {
+			c.Next()
+			return
+		}
+		if c.GetHeader(deviceSignatureHeader) == "" {
+			if signing.routes[c.Request.Method+" "+c.FullPath()] {
+				respondError(c, http.StatusUnauthorized, errCodeSignatureRequired, "This route requires a device signature")
+				c.Abort()
+				return
+			}
+			c.Next()
+			return
+		}
+
+		device, cleanup, err := signing.verify(c)
+		defer cleanup()
+		if err != nil {
+			var serr *signingError
+			if !errors.As(err, &serr) {
+				logWithContext(c.Request.Context(), "Request signature could not be checked: %v", err)
+				serr = &signingError{http.StatusServiceUnavailable, errCodeSigningUnavailable, "Request signatures cannot be checked right now"}
+			}
+			respondError(c, serr.status, serr.code, serr.message)
+			c.Abort()
+			return
+		}
+		c.Set(signedDeviceKey, device)
+		c.Next()
+	}
+}
+
+// signingError is a signature the request is rejected for, as opposed to a store failure
+type signingError struct {
+	status  int
+	code    string
+	message string
+}
+
+func (e *signingError) Error() string {
+	return e.message
+}
+
+func invalidSignature(message string) error {
+	return &signingError{http.StatusUnauthorized, errCodeSignatureInvalid, message}
+}
+
+// verify checks the request's signature and claims its nonce. cleanup removes any spooled body
+// and must be called once the request is done.
+func (s *requestSigning) verify(c *gin.Context) (DeviceKey, func(), error) {
+	cleanup := func() {}
+	ctx := c.Request.Context()
+	deviceID := c.GetHeader(deviceIDHeader)
+	if deviceID == "" {
+		return DeviceKey{}, cleanup, invalidSignature(deviceIDHeader + " is required with " + deviceSignatureHeader)
+	}
+	unix, err := strconv.ParseInt(c.GetHeader(signatureTimeHeader), 10, 64)
+	if err != nil {
+		return DeviceKey{}, cleanup, invalidSignature(signatureTimeHeader + " must be a Unix time in seconds")
+	}
+	if skew := time.Since(time.Unix(unix, 0)); math.Abs(float64(skew)) > float64(s.maxSkew) {
+		return DeviceKey{}, cleanup, &signingError{http.StatusUnauthorized, errCodeSignatureExpired, fmt.Sprintf("The signature timestamp is more than %s from the server's clock", s.maxSkew)}
+	}
+	nonce := c.GetHeader(signatureNonceHeader)
+	if !signingNonce.MatchString(nonce) {
+		return DeviceKey{}, cleanup, invalidSignature(signatureNonceHeader + " must be 16 to 128 letters, digits, '-' or '_'")
+	}
+	signature, err := base64.StdEncoding.DecodeString(c.GetHeader(deviceSignatureHeader))
+	if err != nil {
+		return DeviceKey{}, cleanup, invalidSignature(deviceSignatureHeader + " must be base64")
+	}
+
+	device, err := s.keys.get(ctx, deviceID)
+	if errors.Is(err, errRedisNil) {
+		return DeviceKey{}, cleanup, invalidSignature("The device has no enrolled key")
+	}
+	if err != nil {
+		return DeviceKey{}, cleanup, err
+	}
+	if t := tenantFromContext(ctx); !t.all && device.Org != "" && device.Org != t.org {
+		return DeviceKey{}, cleanup, invalidSignature("The device has no enrolled key")
+	}
+
+	digest, cleanup, err := s.hashBody(c)
+	if err != nil {
+		return DeviceKey{}, cleanup, err
+	}
+	message := signedMessage(c.Request.Method, c.Request.URL.RequestURI(), unix, nonce, digest)
+	if err := verifyDeviceSignature(device, message, signature); err != nil {
+		return DeviceKey{}, cleanup, invalidSignature("The signature does not match the request")
+	}
+
+	// A nonce is only useful while its timestamp is, so it is kept for one skew either side
+	claimed, err := s.nonces.claim(ctx, signingNonceKey(deviceID, nonce), idempotencyRecord{Pending: true}, 2*s.maxSkew)
+	if err != nil {
+		return DeviceKey{}, cleanup, err
+	}
+	if !claimed {
+		return DeviceKey{}, cleanup, &signingError{http.StatusConflict, errCodeRequestReplayed, "This signed request has already been received"}
+	}
+	return device, cleanup, nil
+}
+
+// hashBody reads the request body to hash it, then puts it back for the handler. Bodies too large
+// to hold in memory, such as evidence uploads, are spooled to a temporary file.
+func (s *requestSigning) hashBody(c *gin.Context) (string, func(), error) {
+	cleanup := func() {}
+	h := sha256.New()
+	if c.Request.Body == nil || c.Request.Body == http.NoBody {
+		return hex.EncodeToString(h.Sum(nil)), cleanup, nil
+	}
+	body := io.TeeReader(io.LimitReader(c.Request.Body, s.maxBody+1), h)
+	head, err := io.ReadAll(io.LimitReader(body, maxSignedBodyMemory+1))
+	if err != nil {
+		return "", cleanup, invalidSignature("The request body could not be read")
+	}
+	if len(head) <= maxSignedBodyMemory {
+		c.Request.Body = io.NopCloser(bytes.NewReader(head))
+		return hex.EncodeToString(h.Sum(nil)), cleanup, nil
+	}
+
+	spool, err := os.CreateTemp("", "sih-signed-*")
+	if err != nil {
+		return "", cleanup, err
+	}
+	cleanup = func() {
+		spool.Close()
+		os.Remove(spool.Name())
+	}
+	size, err := io.Copy(spool, io.MultiReader(bytes.NewReader(head), body))
+	if err != nil {
+		return "", cleanup, invalidSignature("The request body could not be read")
+	}
+	if size > s.maxBody {
+		return "", cleanup, &signingError{http.StatusRequestEntityTooLarge, errCodePayloadTooLarge, fmt.Sprintf("Signed requests are limited to %d bytes", s.maxBody)}
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return "", cleanup, err
+	}
+	c.Request.Body = io.NopCloser(spool)
+	return hex.EncodeToString(h.Sum(nil)), cleanup, nil
+}
+
+// signedMessage is what a device signs: the method, request URI with its query, timestamp, nonce
+// and hex SHA-256 of the body, one per line
+func signedMessage(method, uri string, unix int64, nonce, bodyDigest string) []byte {
+	return []byte(strings.Join([]string{method, uri, strconv.FormatInt(unix, 10), nonce, bodyDigest}, "\n"))
+}
+
+func signingNonceKey(deviceID, nonce string) string {
+	sum := sha256.Sum256([]byte(deviceID + "\x00" + nonce))
+	return signingNoncePrefix + hex.EncodeToString(sum[:])
+}
+
+// parseDevicePublicKey decodes an enrolled key and names its signature algorithm
+func parseDevicePublicKey(encoded string) (interface{}, string, error) {
+	der, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, "", err
+	}
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, "", err
+	}
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		if k.Curve != elliptic.P256() {
+			return nil, "", errors.New("only P-256 ECDSA keys are supported")
+		}
+		return k, algECDSAP256, nil
+	case ed25519.PublicKey:
+		return k, algEd25519, nil
+	}
+	return nil, "", fmt.Errorf("unsupported public key type %T", key)
+}
+
+// verifyDeviceSignature checks an ASN.1 ECDSA signature over the SHA-256 of message, or an Ed25519
+// signature over message itself
+func verifyDeviceSignature(device DeviceKey, message, signature []byte) error {
+	key, _, err := parseDevicePublicKey(device.PublicKey)
+	if err != nil {
+		return err
+	}
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(message)
+		if ecdsa.VerifyASN1(k, digest[:], signature) {
+			return nil
+		}
+	case ed25519.PublicKey:
+		if ed25519.Verify(k, message, signature) {
+			return nil
+		}
+	}
+	return errors.New("signature mismatch")
+}
+
+// redisDeviceKeyStore keeps keys in a hash keyed by device ID
+type redisDeviceKeyStore struct {
+	redis *redisClient
+}
+
+func (s redisDeviceKeyStore) get(ctx context.Context, deviceID string) (DeviceKey, error) {
+	var key DeviceKey
+	reply, err := s.redis.Do(ctx, "HGET", deviceKeysKey, deviceID)
+	if err != nil {
+		return key, err
+	}
+	data, ok := reply.([]byte)
+	if !ok {
+		return key, errRedisNil
+	}
+	return key, json.Unmarshal(data, &key)
+}
+
+func (s redisDeviceKeyStore) save(ctx context.Context, key DeviceKey) error {
+	data, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+	_, err = s.redis.Do(ctx, "HSET", deviceKeysKey, key.DeviceID, string(data))
+	return err
+}
+
+func (s redisDeviceKeyStore) remove(ctx context.Context, deviceID string) error {
+	_, err := s.redis.Do(ctx, "HDEL", deviceKeysKey, deviceID)
+	return err
+}
+
+// memoryDeviceKeyStore serves a single gateway instance
+type memoryDeviceKeyStore struct {
+	mu   sync.Mutex
+	keys map[string]DeviceKey
+}
+
+func newMemoryDeviceKeyStore() *memoryDeviceKeyStore {
+	return &memoryDeviceKeyStore{keys: map[string]DeviceKey{}}
+}
+
+func (s *memoryDeviceKeyStore) get(_ context.Context, deviceID string) (DeviceKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.keys[deviceID]
+	if !ok {
+		return DeviceKey{}, errRedisNil
+	}
+	return key, nil
+}
+
+func (s *memoryDeviceKeyStore) save(_ context.Context, key DeviceKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[key.DeviceID] = key
+	return nil
+}
+
+func (s *memoryDeviceKeyStore) remove(_ context.Context, deviceID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.keys, deviceID)
+	return nil
+}
+
+func signingDisabled(c *gin.Context) bool {
+	if signing == nil {
+		respondError(c, http.StatusServiceUnavailable, errCodeSigningDisabled, "Request signing is not enabled")
+		return true
+	}
+	return false
+}
+
+// enrolledDevice reads a device key visible to the caller's organization, or nil if there is none
+func enrolledDevice(ctx context.Context, deviceID string) (*DeviceKey, error) {
+	key, err := signing.keys.get(ctx, deviceID)
+	if errors.Is(err, errRedisNil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if t := tenantFromContext(ctx); !t.all && key.Org != "" && key.Org != t.org {
+		return nil, nil
+	}
+	return &key, nil
+}
+
+// registerDeviceKey enrols a device key. A device that already has one can only rotate it with a
+// request signed by the current key.
+func registerDeviceKey(c *gin.Context) {
+	if signingDisabled(c) {
+		return
+	}
+	var req RegisterDeviceKeyRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+
+	ctx := c.Request.Context()
+	existing, err := signing.keys.get(ctx, req.DeviceID)
+	if err != nil && !errors.Is(err, errRedisNil) {
+		respondServiceError(c, "Failed to read device key", err)
+		return
+	}
+	if err == nil {
+		signed, _ := c.Get(signedDeviceKey)
+		if device, ok := signed.(DeviceKey); !ok || device.DeviceID != existing.DeviceID {
+			respondError(c, http.StatusConflict, errCodeDeviceKeyExists, "The device already has a key; sign the request with it to rotate it")
+			return
+		}
+	}
+
+	_, algorithm, _ := parseDevicePublicKey(req.PublicKey)
+	key := DeviceKey{
+		DeviceID:     req.DeviceID,
+		DigitalID:    req.DigitalID,
+		Algorithm:    algorithm,
+		PublicKey:    req.PublicKey,
+		RegisteredAt: time.Now().UTC().Format(time.RFC3339),
+		Org:          tenantFromContext(ctx).org,
+	}
+	if err := signing.keys.save(ctx, key); err != nil {
+		respondServiceError(c, "Failed to enrol device key", err)
+		return
+	}
+	respondData(c, http.StatusCreated, key)
+}
+
+func revokeDeviceKey(c *gin.Context) {
+	if signingDisabled(c) {
+		return
+	}
+	id, ok := validPathID(c, "deviceId")
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	key, err := enrolledDevice(ctx, id)
+	if err != nil {
+		respondServiceError(c, "Failed to read device key", err)
+		return
+	}
+	if key == nil {
+		respondError(c, http.StatusNotFound, errCodeNotFound, "Device key not found")
+		return
+	}
+	if err := signing.keys.remove(ctx, id); err != nil {
+		respondServiceError(c, "Failed to revoke device key", err)
+		return
+	}
+	respondData(c, http.StatusOK, gin.H{"message": "Device key revoked successfully"})
+}
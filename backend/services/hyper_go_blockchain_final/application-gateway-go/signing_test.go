@@ -0,0 +1,178 @@
+package gateway
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"assetTransfer/sihclient"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRequestSigning(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	signing = &requestSigning{
+		keys:    newMemoryDeviceKeyStore(),
+		nonces:  newMemoryIdempotencyStore(),
+		maxSkew: 5 * time.Minute,
+		maxBody: 4 << 20,
+		routes:  map[string]bool{"POST /api/v1/sos": true},
+	}
+	defer func() { signing = nil }()
+
+	r := gin.New()
+	api := r.Group("/api/v1", signatureMiddleware())
+	var received string
+	echo := func(c *gin.Context) {
+		body, _ := io.ReadAll(c.Request.Body)
+		received = string(body)
+		respondData(c, http.StatusOK, gin.H{"bytes": len(body)})
+	}
+	api.POST("/sos", echo)
+	api.POST("/incident/", echo)
+	api.POST("/devices/keys", registerDeviceKey)
+
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	edPublic, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	encode := func(public interface{}) string {
+		der, err := x509.MarshalPKIXPublicKey(public)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.StdEncoding.EncodeToString(der)
+	}
+
+	nonces := 0
+	send := func(uri, body, device string, key interface{}, at time.Time, nonce string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, uri, strings.NewReader(body))
+		if key != nil {
+			if nonce == "" {
+				nonces++
+				nonce = fmt.Sprintf("nonce-%012d", nonces)
+			}
+			digest := sha256.Sum256([]byte(body))
+			message := signedMessage(http.MethodPost, uri, at.Unix(), nonce, hex.EncodeToString(digest[:]))
+			var signature []byte
+			switch k := key.(type) {
+			case *ecdsa.PrivateKey:
+				sum := sha256.Sum256(message)
+				signature, _ = ecdsa.SignASN1(rand.Reader, k, sum[:])
+			case ed25519.PrivateKey:
+				signature = ed25519.Sign(k, message)
+			}
+			req.Header.Set(deviceIDHeader, device)
+			req.Header.Set(signatureTimeHeader, strconv.FormatInt(at.Unix(), 10))
+			req.Header.Set(signatureNonceHeader, nonce)
+			req.Header.Set(deviceSignatureHeader, base64.StdEncoding.EncodeToString(signature))
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	now := time.Now()
+
+	if w := send("/api/v1/devices/keys", fmt.Sprintf(`{"device_id":"phone-1","public_key":%q}`, encode(&ecKey.PublicKey)), "", nil, now, ""); w.Code != http.StatusCreated {
+		t.Fatalf("expected the key enrolled, got %d: %s", w.Code, w.Body)
+	}
+	if w := send("/api/v1/sos", `{"digital_id":"did:tourist:1"}`, "", nil, now, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected an unsigned SOS rejected, got %d", w.Code)
+	}
+	if w := send("/api/v1/incident/", `{}`, "", nil, now, ""); w.Code != http.StatusOK {
+		t.Errorf("expected an unsigned request to an unlisted route accepted, got %d", w.Code)
+	}
+
+	body := `{"digital_id":"did:tourist:1"}`
+	if w := send("/api/v1/sos?lang=hi", body, "phone-1", ecKey, now, "replayed-nonce-0001"); w.Code != http.StatusOK || received != body {
+		t.Fatalf("expected a signed SOS accepted with its body intact, got %d: %s", w.Code, w.Body)
+	}
+	if w := send("/api/v1/sos?lang=hi", body, "phone-1", ecKey, now, "replayed-nonce-0001"); w.Code != http.StatusConflict {
+		t.Errorf("expected a replay rejected, got %d", w.Code)
+	}
+	if w := send("/api/v1/sos", body, "phone-1", ecKey, now.Add(-10*time.Minute), ""); w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), errCodeSignatureExpired) {
+		t.Errorf("expected a stale signature rejected, got %d: %s", w.Code, w.Body)
+	}
+	if w := send("/api/v1/sos", body, "phone-1", edKey, now, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected a signature by another key rejected, got %d", w.Code)
+	}
+
+	// A large upload is spooled to disk and still reaches the handler whole
+	large := strings.Repeat("x", maxSignedBodyMemory+10)
+	if w := send("/api/v1/sos", large, "phone-1", ecKey, now, ""); w.Code != http.StatusOK || len(received) != len(large) {
+		t.Errorf("expected a spooled body accepted, got %d with %d bytes", w.Code, len(received))
+	}
+
+	rotate := fmt.Sprintf(`{"device_id":"phone-1","public_key":%q}`, encode(edPublic))
+	if w := send("/api/v1/devices/keys", rotate, "", nil, now, ""); w.Code != http.StatusConflict {
+		t.Errorf("expected an unsigned key replacement refused, got %d", w.Code)
+	}
+	if w := send("/api/v1/devices/keys", rotate, "phone-1", ecKey, now, ""); w.Code != http.StatusCreated {
+		t.Fatalf("expected a rotation signed by the current key accepted, got %d: %s", w.Code, w.Body)
+	}
+	if w := send("/api/v1/sos", body, "phone-1", edKey, now, ""); w.Code != http.StatusOK {
+		t.Errorf("expected the rotated Ed25519 key accepted, got %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestSDKSignedRetry(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	signing = &requestSigning{
+		keys:    newMemoryDeviceKeyStore(),
+		nonces:  newMemoryIdempotencyStore(),
+		maxSkew: 5 * time.Minute,
+		maxBody: 4 << 20,
+		routes:  map[string]bool{"POST /api/v1/sos": true},
+	}
+	defer func() { signing = nil }()
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	signing.keys.save(context.Background(), DeviceKey{DeviceID: "phone-1", Algorithm: algECDSAP256, PublicKey: base64.StdEncoding.EncodeToString(der)})
+
+	r := gin.New()
+	attempts := 0
+	r.POST("/api/v1/sos", signatureMiddleware(), idempotencyMiddleware(), func(c *gin.Context) {
+		if attempts++; attempts == 1 {
+			respondError(c, http.StatusServiceUnavailable, errCodeUnavailable, "peer down")
+			return
+		}
+		respondData(c, http.StatusCreated, SOSResult{AlertID: "SOS-1", DigitalID: "did:sih:tourist001"})
+	})
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	client, err := sihclient.New(server.URL, sihclient.Options{Device: &sihclient.DeviceSigner{DeviceID: "phone-1", Key: key}, RetryBackoff: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lat, lng := 25.57, 91.89
+	req := sihclient.SOSRequest{DigitalID: "did:sih:tourist001", Latitude: &lat, Longitude: &lng}
+	alert, receipt, err := client.RaiseSOS(context.Background(), req)
+	if err != nil || alert.AlertID != "SOS-1" || receipt == nil || attempts != 2 {
+		t.Fatalf("expected the retry signed afresh and accepted, got %+v, %v after %d attempts", alert, err, attempts)
+	}
+
+	// The same idempotency key is answered from the first response without running the handler
+	_, err = client.Do(context.Background(), sihclient.Request{Method: http.MethodPost, Path: "/sos", Body: req, IdempotencyKey: "sos-key"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed, err := client.Do(context.Background(), sihclient.Request{Method: http.MethodPost, Path: "/sos", Body: req, IdempotencyKey: "sos-key"}, nil)
+	if err != nil || !replayed.Replayed || attempts != 3 {
+		t.Errorf("expected the repeat replayed, got %+v, %v after %d attempts", replayed, err, attempts)
+	}
+}
@@ -0,0 +1,413 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package sihclient calls the SIH gateway from Go. It authenticates with an API key, a session
+// or both, signs requests with an enrolled device key, retries what is safe to retry under one
+// Idempotency-Key, unwraps the response envelope into typed results and pages through bookmarked
+// searches. It depends only on the standard library so partners can vendor it without the
+// gateway's Fabric dependencies.
+package sihclient
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	mathrand "math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Headers the gateway reads and writes
+const (
+	APIKeyHeader         = "X-API-Key"
+	TargetHeader         = "X-Fabric-Target"
+	IdempotencyHeader    = "Idempotency-Key"
+	ReplayedHeader       = "Idempotent-Replayed"
+	DeviceIDHeader       = "X-Device-ID"
+	SignatureHeader      = "X-Signature"
+	SignatureTimeHeader  = "X-Signature-Timestamp"
+	SignatureNonceHeader = "X-Signature-Nonce"
+)
+
+// Error codes callers commonly branch on
+const (
+	CodeValidation            = "VALIDATION_FAILED"
+	CodeNotFound              = "NOT_FOUND"
+	CodeAlreadyExists         = "ALREADY_EXISTS"
+	CodeMVCCConflict          = "MVCC_READ_CONFLICT"
+	CodeRateLimited           = "RATE_LIMITED"
+	CodeTokenExpired          = "TOKEN_EXPIRED"
+	CodeIdempotencyInProgress = "IDEMPOTENCY_IN_PROGRESS"
+)
+
+const (
+	defaultMaxRetries   = 3
+	defaultRetryBackoff = 200 * time.Millisecond
+	defaultMaxBackoff   = 5 * time.Second
+
+	// Error bodies are read up to this size
+	maxErrorBody = 1 << 20
+)
+
+// Options configure a Client. APIKey and Session may both be set: the session is sent while it
+// lasts, and the key is what Login trades for a new one.
+type Options struct {
+	APIKey string
+	// Session resumes a session saved from an earlier Login
+	Session *Session
+	// OnSession is called with every session the client obtains or refreshes, so it can be saved,
+	// and with nil after Logout
+	OnSession func(*Session)
+	// Device signs every request with an enrolled device key
+	Device *DeviceSigner
+	// Target names the channel and chaincode to call when the gateway serves several
+	Target string
+	// Language asks for error messages in this language, as an Accept-Language value
+	Language string
+	// HTTPClient sends the requests; set its transport's TLS config for a private CA or mutual TLS
+	HTTPClient *http.Client
+	// MaxRetries is how many times a failed call is retried, 3 by default; negative disables retries
+	MaxRetries int
+	// RetryBackoff and MaxBackoff bound the jittered exponential delay between attempts when the
+	// gateway gives no Retry-After
+	RetryBackoff time.Duration
+	MaxBackoff   time.Duration
+	UserAgent    string
+}
+
+// Client calls one gateway. It is safe for concurrent use.
+type Client struct {
+	base *url.URL
+	opts Options
+	http *http.Client
+
+	mu         sync.Mutex
+	session    *Session
+	refreshing sync.Mutex
+}
+
+// New returns a client for the gateway at baseURL, such as https://gateway.example:8080
+func New(baseURL string, opts Options) (*Client, error) {
+	base, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, fmt.Errorf("sihclient: base URL must be an http or https URL, got %q", baseURL)
+	}
+	if opts.Device != nil {
+		if err := opts.Device.check(); err != nil {
+			return nil, err
+		}
+	}
+	if opts.MaxRetries == 0 {
+		opts.MaxRetries = defaultMaxRetries
+	}
+	opts.RetryBackoff = cmp.Or(opts.RetryBackoff, defaultRetryBackoff)
+	opts.MaxBackoff = cmp.Or(opts.MaxBackoff, defaultMaxBackoff)
+	return &Client{base: base, opts: opts, http: cmp.Or(opts.HTTPClient, http.DefaultClient), session: opts.Session}, nil
+}
+
+// Error is a failed call: the gateway's error envelope, or the status of a response that was not one
+type Error struct {
+	Status  int          `json:"-"`
+	Code    string       `json:"code"`
+	Message string       `json:"message"`
+	Fields  []FieldError `json:"fields,omitempty"`
+	TxID    string       `json:"-"`
+	// RetryAfter is the wait the gateway asked for, if any
+	RetryAfter time.Duration `json:"-"`
+}
+
+// FieldError is one rejected request field
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	message := fmt.Sprintf("sihclient: %d %s: %s", e.Status, e.Code, e.Message)
+	for _, field := range e.Fields {
+		message += fmt.Sprintf("; %s: %s", field.Field, field.Message)
+	}
+	return message
+}
+
+// IsCode reports whether err is a gateway error with the given code
+func IsCode(err error, code string) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.Code == code
+}
+
+// Receipt describes the response to a call. Writes carry the transaction that recorded them.
+type Receipt struct {
+	TxID        string  `json:"tx_id,omitempty"`
+	BlockNumber *uint64 `json:"block_number,omitempty"`
+	Timestamp   string  `json:"timestamp"`
+	// Replayed is set when the gateway answered from the record of an earlier attempt
+	Replayed bool `json:"-"`
+}
+
+// envelope is the gateway's response body with its data kept undecoded
+type envelope struct {
+	Receipt
+	Success bool            `json:"success"`
+	Data    json.RawMessage `json:"data,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+// Request is a call to any gateway route. Path is relative to /api/v1 unless it starts with
+// /api/, /health or /metrics.
+type Request struct {
+	Method string
+	Path   string
+	Query  url.Values
+	// Body is sent as JSON when not nil
+	Body interface{}
+	// IdempotencyKey is generated for writes when empty. A caller's own key is never replaced.
+	IdempotencyKey string
+}
+
+// Do sends a request and decodes the envelope's data into out when out is not nil
+func (c *Client) Do(ctx context.Context, r Request, out interface{}) (*Receipt, error) {
+	return c.do(ctx, r, out, true)
+}
+
+func (c *Client) do(ctx context.Context, r Request, out interface{}, withSession bool) (*Receipt, error) {
+	var body []byte
+	if r.Body != nil {
+		var err error
+		if body, err = json.Marshal(r.Body); err != nil {
+			return nil, fmt.Errorf("sihclient: failed to encode request: %w", err)
+		}
+	}
+	generated := false
+	if r.IdempotencyKey == "" && r.Method != http.MethodGet && r.Method != http.MethodHead {
+		r.IdempotencyKey, generated = newToken(), true
+	}
+
+	refreshed := false
+	for attempt := 0; ; attempt++ {
+		accessToken := ""
+		if withSession {
+			accessToken = c.accessToken()
+		}
+		resp, err := c.send(ctx, r, body, accessToken)
+		if err != nil {
+			if ctx.Err() != nil || !c.retry(ctx, attempt, 0) {
+				return nil, err
+			}
+			continue
+		}
+		if resp.StatusCode < 300 {
+			return decodeEnvelope(resp, out)
+		}
+
+		apiErr := readError(resp)
+		switch {
+		case apiErr.Code == CodeTokenExpired && accessToken != "" && !refreshed:
+			// A refresh is not an attempt; the same idempotency key makes the repeat safe
+			refreshed, attempt = true, attempt-1
+			if err := c.refresh(ctx, accessToken); err != nil {
+				return nil, fmt.Errorf("sihclient: session expired and could not be refreshed: %w", err)
+			}
+		case retryable(apiErr, generated) && c.retry(ctx, attempt, apiErr.RetryAfter):
+			if apiErr.Code == CodeMVCCConflict {
+				// The conflicting transaction recorded nothing, but its failure is kept under the
+				// old key, so the next attempt needs a new one
+				r.IdempotencyKey = newToken()
+			}
+		default:
+			return nil, apiErr
+		}
+	}
+}
+
+// retryable reports whether an attempt failed for a reason another attempt can outlive. Writes
+// are retried under their idempotency key, so one that did commit is answered from its record.
+func retryable(err *Error, generatedKey bool) bool {
+	switch err.Status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	case http.StatusConflict:
+		return err.Code == CodeIdempotencyInProgress || (err.Code == CodeMVCCConflict && generatedKey)
+	}
+	return false
+}
+
+// retry waits before the next attempt, reporting false when attempts are used up or ctx ends
+func (c *Client) retry(ctx context.Context, attempt int, retryAfter time.Duration) bool {
+	if attempt >= c.opts.MaxRetries {
+		return false
+	}
+	wait := retryAfter
+	if wait <= 0 {
+		ceiling := min(c.opts.RetryBackoff<<attempt, c.opts.MaxBackoff)
+		wait = ceiling/2 + mathrand.N(ceiling/2+1)
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+func (c *Client) url(path string, query url.Values) string {
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	if !strings.HasPrefix(path, "/api/") && !strings.HasPrefix(path, "/health") && !strings.HasPrefix(path, "/metrics") {
+		path = "/api/v1" + path
+	}
+	u := c.base.String() + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	return u
+}
+
+// send makes one attempt, with a fresh signature since the gateway refuses a nonce twice
+func (c *Client) send(ctx context.Context, r Request, body []byte, accessToken string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, r.Method, c.url(r.Path, r.Query), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	if r.IdempotencyKey != "" {
+		req.Header.Set(IdempotencyHeader, r.IdempotencyKey)
+	}
+	if c.opts.APIKey != "" {
+		req.Header.Set(APIKeyHeader, c.opts.APIKey)
+	}
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+	if c.opts.Target != "" {
+		req.Header.Set(TargetHeader, c.opts.Target)
+	}
+	if c.opts.Language != "" {
+		req.Header.Set("Accept-Language", c.opts.Language)
+	}
+	if c.opts.UserAgent != "" {
+		req.Header.Set("User-Agent", c.opts.UserAgent)
+	}
+	if c.opts.Device != nil {
+		if err := c.opts.Device.sign(req, body, time.Now()); err != nil {
+			return nil, err
+		}
+	}
+	return c.http.Do(req)
+}
+
+func decodeEnvelope(resp *http.Response, out interface{}) (*Receipt, error) {
+	defer resp.Body.Close()
+	var env envelope
+	if err := json.NewDecoder(resp.Body).Decode(&env); err != nil {
+		return nil, fmt.Errorf("sihclient: unexpected response from the gateway: %w", err)
+	}
+	env.Receipt.Replayed = resp.Header.Get(ReplayedHeader) == "true"
+	if out != nil && len(env.Data) > 0 {
+		if err := json.Unmarshal(env.Data, out); err != nil {
+			return nil, fmt.Errorf("sihclient: unexpected response data: %w", err)
+		}
+	}
+	return &env.Receipt, nil
+}
+
+// readError turns an error response into an Error, keeping the body of one that is not an
+// envelope as the message
+func readError(resp *http.Response) *Error {
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	apiErr := &Error{Status: resp.StatusCode}
+	var env envelope
+	if json.Unmarshal(data, &env) == nil && env.Error != nil {
+		*apiErr = *env.Error
+		apiErr.Status, apiErr.TxID = resp.StatusCode, env.TxID
+	} else {
+		apiErr.Code, apiErr.Message = http.StatusText(resp.StatusCode), strings.TrimSpace(string(data))
+	}
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		apiErr.RetryAfter = time.Duration(seconds) * time.Second
+	}
+	return apiErr
+}
+
+func newToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// DeviceSigner signs requests as an enrolled device, with the private half of the key registered
+// under DeviceID. Key is an *ecdsa.PrivateKey on P-256, an ed25519.PrivateKey, or a hardware
+// signer for either.
+type DeviceSigner struct {
+	DeviceID string
+	Key      crypto.Signer
+}
+
+func (d *DeviceSigner) check() error {
+	if d.DeviceID == "" || d.Key == nil {
+		return errors.New("sihclient: a device signer needs a device ID and a key")
+	}
+	switch key := d.Key.Public().(type) {
+	case *ecdsa.PublicKey:
+		if key.Curve.Params().Name != "P-256" {
+			return errors.New("sihclient: device ECDSA keys must be on P-256")
+		}
+	case ed25519.PublicKey:
+	default:
+		return fmt.Errorf("sihclient: device keys must be ECDSA P-256 or Ed25519, got %T", key)
+	}
+	return nil
+}
+
+// sign adds the device headers. The signed message is the method, request URI with its query,
+// Unix time, nonce and hex SHA-256 of the body, one per line.
+func (d *DeviceSigner) sign(req *http.Request, body []byte, now time.Time) error {
+	nonce := make([]byte, 18)
+	rand.Read(nonce)
+	encodedNonce := base64.RawURLEncoding.EncodeToString(nonce)
+	digest := sha256.Sum256(body)
+	unix := strconv.FormatInt(now.Unix(), 10)
+	message := []byte(strings.Join([]string{req.Method, req.URL.RequestURI(), unix, encodedNonce, hex.EncodeToString(digest[:])}, "\n"))
+
+	var signature []byte
+	var err error
+	if _, ok := d.Key.Public().(ed25519.PublicKey); ok {
+		signature, err = d.Key.Sign(rand.Reader, message, crypto.Hash(0))
+	} else {
+		sum := sha256.Sum256(message)
+		signature, err = d.Key.Sign(rand.Reader, sum[:], crypto.SHA256)
+	}
+	if err != nil {
+		return fmt.Errorf("sihclient: failed to sign request: %w", err)
+	}
+	req.Header.Set(DeviceIDHeader, d.DeviceID)
+	req.Header.Set(SignatureTimeHeader, unix)
+	req.Header.Set(SignatureNonceHeader, encodedNonce)
+	req.Header.Set(SignatureHeader, base64.StdEncoding.EncodeToString(signature))
+	return nil
+}
@@ -0,0 +1,205 @@
+package sihclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeGateway answers with the gateway's envelope
+type fakeGateway struct {
+	mu       sync.Mutex
+	requests []*http.Request
+	handle   func(n int, w http.ResponseWriter, r *http.Request)
+}
+
+func (f *fakeGateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.requests = append(f.requests, r)
+	n := len(f.requests)
+	f.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	f.handle(n, w, r)
+}
+
+func respond(w http.ResponseWriter, status int, data interface{}) {
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "data": data, "tx_id": "tx-1", "timestamp": "2026-10-01T00:00:00Z"})
+}
+
+func fail(w http.ResponseWriter, status int, code, message string) {
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": map[string]string{"code": code, "message": message}, "timestamp": "2026-10-01T00:00:00Z"})
+}
+
+func newTestClient(t *testing.T, f *fakeGateway, opts Options) *Client {
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	opts.RetryBackoff, opts.MaxBackoff = time.Millisecond, 5*time.Millisecond
+	client, err := New(server.URL, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestDoRetries(t *testing.T) {
+	f := &fakeGateway{handle: func(n int, w http.ResponseWriter, r *http.Request) {
+		switch n {
+		case 1:
+			fail(w, http.StatusServiceUnavailable, "FABRIC_UNAVAILABLE", "peer down")
+		case 2:
+			fail(w, http.StatusConflict, CodeMVCCConflict, "read conflict")
+		default:
+			w.Header().Set(ReplayedHeader, "true")
+			respond(w, http.StatusCreated, map[string]string{"incidentID": "INC-1"})
+		}
+	}}
+	client := newTestClient(t, f, Options{APIKey: "key-1", Target: "tourism"})
+
+	receipt, err := client.CreateIncident(context.Background(), CreateIncidentRequest{IncidentID: "INC-1", Reporter: "officer"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if receipt.TxID != "tx-1" || !receipt.Replayed || len(f.requests) != 3 {
+		t.Errorf("expected the third attempt to succeed, got %+v after %d requests", receipt, len(f.requests))
+	}
+	first, second, third := f.requests[0].Header, f.requests[1].Header, f.requests[2].Header
+	if first.Get(IdempotencyHeader) == "" || first.Get(IdempotencyHeader) != second.Get(IdempotencyHeader) {
+		t.Error("expected an unavailable gateway retried under the same key")
+	}
+	if third.Get(IdempotencyHeader) == second.Get(IdempotencyHeader) {
+		t.Error("expected a read conflict retried under a new key")
+	}
+	if first.Get(APIKeyHeader) != "key-1" || first.Get(TargetHeader) != "tourism" || f.requests[0].URL.Path != "/api/v1/incident/" {
+		t.Errorf("unexpected request %s %v", f.requests[0].URL.Path, first)
+	}
+
+	// A caller's own key is never replaced, so a read conflict under it is returned
+	f.requests = nil
+	f.handle = func(n int, w http.ResponseWriter, r *http.Request) {
+		fail(w, http.StatusConflict, CodeMVCCConflict, "read conflict")
+	}
+	_, err = client.Do(context.Background(), Request{Method: http.MethodPost, Path: "/incident/", Body: struct{}{}, IdempotencyKey: "mine"}, nil)
+	if !IsCode(err, CodeMVCCConflict) || len(f.requests) != 1 {
+		t.Errorf("expected the conflict returned without a retry, got %v after %d requests", err, len(f.requests))
+	}
+}
+
+func TestDoErrors(t *testing.T) {
+	f := &fakeGateway{handle: func(n int, w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"success": false, "error": {"code": "VALIDATION_FAILED", "message": "request validation failed",
+			"fields": [{"field": "consentHash", "message": "must be a valid SHA-256 hash"}]}, "timestamp": "2026-10-01T00:00:00Z"}`))
+	}}
+	client := newTestClient(t, f, Options{MaxRetries: -1})
+
+	_, err := client.CreateDID(context.Background(), CreateDIDRequest{DigitalID: "did:sih:tourist001"})
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.Status != http.StatusBadRequest || apiErr.Code != CodeValidation || len(apiErr.Fields) != 1 {
+		t.Fatalf("expected the validation error decoded, got %v", err)
+	}
+	if len(f.requests) != 1 {
+		t.Errorf("expected a rejected request not retried, got %d requests", len(f.requests))
+	}
+
+	f.handle = func(n int, w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "7")
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte("slow down"))
+	}
+	_, err = client.GetDID(context.Background(), "did:sih:tourist001")
+	if !errors.As(err, &apiErr) || apiErr.Code != "Too Many Requests" || apiErr.Message != "slow down" || apiErr.RetryAfter != 7*time.Second {
+		t.Errorf("expected a plain error response kept, got %+v", apiErr)
+	}
+}
+
+func TestSessionRefresh(t *testing.T) {
+	var saved []*Session
+	f := &fakeGateway{}
+	f.handle = func(n int, w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/auth/refresh":
+			var body struct {
+				RefreshToken string `json:"refresh_token"`
+			}
+			json.NewDecoder(r.Body).Decode(&body)
+			if body.RefreshToken != "refresh-1" {
+				fail(w, http.StatusUnauthorized, "REFRESH_TOKEN_REUSED", "reused")
+				return
+			}
+			respond(w, http.StatusOK, Session{AccessToken: "access-2", RefreshToken: "refresh-2", SessionID: "s1"})
+		case "/api/v1/did/did:sih:tourist001":
+			if r.Header.Get("Authorization") != "Bearer access-2" {
+				fail(w, http.StatusUnauthorized, CodeTokenExpired, "expired")
+				return
+			}
+			respond(w, http.StatusOK, DIDDocument{DigitalID: "did:sih:tourist001", Issuer: "Org1"})
+		}
+	}
+	client := newTestClient(t, f, Options{
+		Session:   &Session{AccessToken: "access-1", RefreshToken: "refresh-1", SessionID: "s1"},
+		OnSession: func(s *Session) { saved = append(saved, s) },
+	})
+
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if doc, err := client.GetDID(context.Background(), "did:sih:tourist001"); err != nil || doc.Issuer != "Org1" {
+				t.Errorf("expected the DID after a refresh, got %+v, %v", doc, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if len(saved) != 1 || saved[0].RefreshToken != "refresh-2" {
+		t.Errorf("expected one refresh saved, got %+v", saved)
+	}
+	if md, _ := client.RPCCredentials().GetRequestMetadata(context.Background()); md["authorization"] != "Bearer access-2" {
+		t.Errorf("expected gRPC calls to carry the new token, got %v", md)
+	}
+}
+
+func TestIncidentPages(t *testing.T) {
+	pages := map[string]IncidentPage{
+		"":   {Incidents: []IncidentDocument{{IncidentID: "INC-1"}, {IncidentID: "INC-2"}}, Bookmark: "b1"},
+		"b1": {Incidents: []IncidentDocument{{IncidentID: "INC-3"}}, Bookmark: "b2"},
+		"b2": {Incidents: []IncidentDocument{}, Bookmark: "b2"},
+	}
+	f := &fakeGateway{handle: func(n int, w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("status") != "open" || r.URL.Query().Get("page_size") != "2" {
+			fail(w, http.StatusBadRequest, CodeValidation, "filters lost")
+			return
+		}
+		respond(w, http.StatusOK, pages[r.URL.Query().Get("bookmark")])
+	}}
+	client := newTestClient(t, f, Options{})
+
+	var ids []string
+	for incident, err := range client.Incidents(context.Background(), IncidentSearch{Status: "open", PageSize: 2}) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, incident.IncidentID)
+	}
+	if len(ids) != 3 || len(f.requests) != 3 {
+		t.Errorf("expected three incidents over three pages, got %v from %d requests", ids, len(f.requests))
+	}
+
+	f.requests = nil
+	for incident := range client.Incidents(context.Background(), IncidentSearch{Status: "open", PageSize: 2}) {
+		if incident.IncidentID == "INC-1" {
+			break
+		}
+	}
+	if len(f.requests) != 1 {
+		t.Errorf("expected breaking out to stop fetching, got %d requests", len(f.requests))
+	}
+}
@@ -0,0 +1,50 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sihclient
+
+import (
+	"context"
+	"strings"
+)
+
+// RPCCredentials authenticate gRPC calls to the gateway as the client does its REST calls. They
+// satisfy google.golang.org/grpc/credentials.PerRPCCredentials without this package importing
+// gRPC:
+//
+//	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(tlsCreds), grpc.WithPerRPCCredentials(client.RPCCredentials()))
+//
+// gRPC calls do not refresh an expired session; call Refresh when one is refused as
+// unauthenticated and retry.
+type RPCCredentials struct {
+	client *Client
+}
+
+// RPCCredentials returns the credentials for gRPC calls
+func (c *Client) RPCCredentials() RPCCredentials {
+	return RPCCredentials{c}
+}
+
+// GetRequestMetadata returns the API key, session token and target as gRPC metadata
+func (r RPCCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	md := map[string]string{}
+	if r.client.opts.APIKey != "" {
+		md[strings.ToLower(APIKeyHeader)] = r.client.opts.APIKey
+	}
+	if token := r.client.accessToken(); token != "" {
+		md["authorization"] = "Bearer " + token
+	}
+	if r.client.opts.Target != "" {
+		md[strings.ToLower(TargetHeader)] = r.client.opts.Target
+	}
+	return md, nil
+}
+
+// RequireTransportSecurity keeps credentials off plaintext connections unless the gateway's REST
+// URL is itself plain http, as on a development network
+func (r RPCCredentials) RequireTransportSecurity() bool {
+	return r.client.base.Scheme == "https"
+}
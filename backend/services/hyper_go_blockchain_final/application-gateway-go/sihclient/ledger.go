@@ -0,0 +1,374 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sihclient
+
+import (
+	"context"
+	"iter"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// DIDDocument is a tourist's digital ID as recorded on the ledger
+type DIDDocument struct {
+	DocType     string `json:"doc_type"`
+	DigitalID   string `json:"digital_id"`
+	ConsentHash string `json:"consent_hash"`
+	IssuedAt    string `json:"issued_at"`
+	ExpiresAt   string `json:"expires_at"`
+	Issuer      string `json:"issuer"`
+	TxID        string `json:"tx_id"`
+}
+
+// DIDVerification is the verdict on whether a DID can be trusted at a checkpoint
+type DIDVerification struct {
+	DigitalID  string `json:"digital_id"`
+	Valid      bool   `json:"valid"`
+	Exists     bool   `json:"exists"`
+	Expired    bool   `json:"expired"`
+	Revoked    bool   `json:"revoked"`
+	Reason     string `json:"reason,omitempty"`
+	Issuer     string `json:"issuer,omitempty"`
+	IssuedAt   string `json:"issued_at,omitempty"`
+	ExpiresAt  string `json:"expires_at,omitempty"`
+	VerifiedAt string `json:"verified_at"`
+	TxID       string `json:"tx_id,omitempty"`
+}
+
+// IncidentDocument is an incident record
+type IncidentDocument struct {
+	DocType             string `json:"doc_type"`
+	IncidentID          string `json:"incident_id"`
+	IncidentSummaryHash string `json:"incident_summary_hash"`
+	CreatedAt           string `json:"created_at"`
+	Reporter            string `json:"reporter"`
+	Status              string `json:"status,omitempty"`
+	Severity            string `json:"severity,omitempty"`
+	Category            string `json:"category,omitempty"`
+	SubjectID           string `json:"subject_id,omitempty"`
+	Geohash             string `json:"geohash,omitempty"`
+	AcknowledgedAt      string `json:"acknowledged_at,omitempty"`
+	ResolvedAt          string `json:"resolved_at,omitempty"`
+	MergedInto          string `json:"merged_into,omitempty"`
+	OwnerOrg            string `json:"owner_org,omitempty"`
+	TxID                string `json:"tx_id"`
+}
+
+// IncidentPage is one page of incident search results
+type IncidentPage struct {
+	Incidents []IncidentDocument `json:"incidents"`
+	Bookmark  string             `json:"bookmark,omitempty"`
+	Count     int                `json:"count"`
+}
+
+// EvidenceDocument is evidence anchored to an incident
+type EvidenceDocument struct {
+	DocType          string `json:"doc_type"`
+	EvidenceID       string `json:"evidence_id,omitempty"`
+	EvidenceHash     string `json:"evidence_hash"`
+	IncidentID       string `json:"incident_id"`
+	MediaType        string `json:"media_type"`
+	UploadedBy       string `json:"uploaded_by"`
+	CreatedAt        string `json:"created_at"`
+	CID              string `json:"cid,omitempty"`
+	EncryptionKeyRef string `json:"encryption_key_ref,omitempty"`
+	OwnerOrg         string `json:"owner_org,omitempty"`
+	TxID             string `json:"tx_id"`
+}
+
+// AuditDocument is an audit log entry
+type AuditDocument struct {
+	DocType   string `json:"doc_type"`
+	AuditHash string `json:"audit_hash"`
+	Actor     string `json:"actor"`
+	Action    string `json:"action"`
+	TargetID  string `json:"target_id"`
+	Timestamp string `json:"timestamp"`
+	TxID      string `json:"tx_id"`
+	Submitter string `json:"submitter,omitempty"`
+	Route     string `json:"route,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+	Status    string `json:"status,omitempty"`
+}
+
+// AuditPage is one page of audit search results
+type AuditPage struct {
+	Audits   []AuditDocument `json:"audits"`
+	Bookmark string          `json:"bookmark,omitempty"`
+	Count    int             `json:"count"`
+}
+
+type CreateDIDRequest struct {
+	DigitalID   string `json:"digitalID"`
+	ConsentHash string `json:"consentHash"`
+	ExpiresAt   string `json:"expiresAt"`
+	Issuer      string `json:"issuer"`
+}
+
+type UpdateDIDRequest struct {
+	ConsentHash string `json:"consentHash"`
+	ExpiresAt   string `json:"expiresAt"`
+	Updater     string `json:"updater"`
+}
+
+type CreateIncidentRequest struct {
+	IncidentID          string   `json:"incidentID"`
+	IncidentSummaryHash string   `json:"incidentSummaryHash"`
+	Reporter            string   `json:"reporter"`
+	Severity            string   `json:"severity,omitempty"`
+	Category            string   `json:"category,omitempty"`
+	DigitalID           string   `json:"digitalID,omitempty"`
+	Latitude            *float64 `json:"latitude,omitempty"`
+	Longitude           *float64 `json:"longitude,omitempty"`
+}
+
+type UpdateIncidentRequest struct {
+	IncidentSummaryHash string `json:"incidentSummaryHash"`
+	Updater             string `json:"updater"`
+}
+
+type CreateEvidenceRequest struct {
+	EvidenceID   string `json:"evidenceID"`
+	EvidenceHash string `json:"evidenceHash"`
+	IncidentID   string `json:"incidentID"`
+	MediaType    string `json:"mediaType"`
+	UploadedBy   string `json:"uploadedBy"`
+}
+
+type UpdateEvidenceRequest struct {
+	EvidenceHash string `json:"evidenceHash"`
+	MediaType    string `json:"mediaType"`
+	Updater      string `json:"updater"`
+}
+
+// IncidentSearch filters an incident search. From and To are RFC 3339 times.
+type IncidentSearch struct {
+	From     string
+	To       string
+	Status   string
+	Severity string
+	Reporter string
+	PageSize int
+	Bookmark string
+}
+
+// AuditSearch filters an audit search
+type AuditSearch struct {
+	Actor    string
+	Action   string
+	From     string
+	To       string
+	PageSize int
+	Bookmark string
+}
+
+// EvidenceFilter narrows an incident's evidence. Sort is created_at, media_type or uploaded_by,
+// descending with a leading "-".
+type EvidenceFilter struct {
+	MediaType  string
+	UploadedBy string
+	From       string
+	To         string
+	Sort       string
+}
+
+// SOSRequest raises an SOS alert for a tourist
+type SOSRequest struct {
+	// AlertID lets clients that queue alerts offline choose the ID; one is generated otherwise
+	AlertID   string   `json:"alertID,omitempty"`
+	DigitalID string   `json:"digitalID"`
+	Latitude  *float64 `json:"latitude"`
+	Longitude *float64 `json:"longitude"`
+	Accuracy  float64  `json:"accuracy,omitempty"`
+	Message   string   `json:"message,omitempty"`
+	Source    string   `json:"source,omitempty"`
+	// Confirmed skips the confirmation window, for alerts whose countdown already ran on the device
+	Confirmed bool `json:"confirmed,omitempty"`
+}
+
+// SOSAlert is a raised alert, or one held for confirmation when Status is set. A held alert has
+// no receipt until it escalates.
+type SOSAlert struct {
+	AlertID        string `json:"alert_id"`
+	DigitalID      string `json:"digital_id"`
+	LocationHash   string `json:"location_hash,omitempty"`
+	Contacts       int    `json:"emergency_contacts,omitempty"`
+	NotificationID string `json:"notification_id,omitempty"`
+	Status         string `json:"status,omitempty"`
+	TriggeredAt    string `json:"triggered_at,omitempty"`
+	EscalatesAt    string `json:"escalates_at,omitempty"`
+}
+
+func query(pairs ...string) url.Values {
+	values := url.Values{}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if pairs[i+1] != "" {
+			values.Set(pairs[i], pairs[i+1])
+		}
+	}
+	return values
+}
+
+func pageSize(n int) string {
+	if n <= 0 {
+		return ""
+	}
+	return strconv.Itoa(n)
+}
+
+func actorBody(actor string) interface{} {
+	return struct {
+		Actor string `json:"actor"`
+	}{actor}
+}
+
+func fetch[T any](ctx context.Context, c *Client, path string, q url.Values) (*T, error) {
+	var out T
+	if _, err := c.Do(ctx, Request{Method: http.MethodGet, Path: path, Query: q}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func (c *Client) write(ctx context.Context, method, path string, body interface{}) (*Receipt, error) {
+	return c.Do(ctx, Request{Method: method, Path: path, Body: body}, nil)
+}
+
+// CreateDID issues a digital ID
+func (c *Client) CreateDID(ctx context.Context, req CreateDIDRequest) (*Receipt, error) {
+	return c.write(ctx, http.MethodPost, "/did/", req)
+}
+
+func (c *Client) GetDID(ctx context.Context, digitalID string) (*DIDDocument, error) {
+	return fetch[DIDDocument](ctx, c, "/did/"+url.PathEscape(digitalID), nil)
+}
+
+// VerifyDID checks a DID at a checkpoint. An unknown or revoked DID is a verdict, not an error.
+func (c *Client) VerifyDID(ctx context.Context, digitalID string) (*DIDVerification, error) {
+	return fetch[DIDVerification](ctx, c, "/did/"+url.PathEscape(digitalID)+"/verify", nil)
+}
+
+func (c *Client) UpdateDID(ctx context.Context, digitalID string, req UpdateDIDRequest) (*Receipt, error) {
+	return c.write(ctx, http.MethodPut, "/did/"+url.PathEscape(digitalID), req)
+}
+
+func (c *Client) DeleteDID(ctx context.Context, digitalID, actor string) (*Receipt, error) {
+	return c.write(ctx, http.MethodDelete, "/did/"+url.PathEscape(digitalID), actorBody(actor))
+}
+
+func (c *Client) CreateIncident(ctx context.Context, req CreateIncidentRequest) (*Receipt, error) {
+	return c.write(ctx, http.MethodPost, "/incident/", req)
+}
+
+func (c *Client) GetIncident(ctx context.Context, incidentID string) (*IncidentDocument, error) {
+	return fetch[IncidentDocument](ctx, c, "/incident/"+url.PathEscape(incidentID), nil)
+}
+
+func (c *Client) UpdateIncident(ctx context.Context, incidentID string, req UpdateIncidentRequest) (*Receipt, error) {
+	return c.write(ctx, http.MethodPut, "/incident/"+url.PathEscape(incidentID), req)
+}
+
+// UpdateIncidentStatus moves an incident to acknowledged, resolved or closed
+func (c *Client) UpdateIncidentStatus(ctx context.Context, incidentID, status, updater string) (*Receipt, error) {
+	body := struct {
+		Status  string `json:"status"`
+		Updater string `json:"updater"`
+	}{status, updater}
+	return c.write(ctx, http.MethodPut, "/incident/"+url.PathEscape(incidentID)+"/status", body)
+}
+
+func (c *Client) DeleteIncident(ctx context.Context, incidentID, actor string) (*Receipt, error) {
+	return c.write(ctx, http.MethodDelete, "/incident/"+url.PathEscape(incidentID), actorBody(actor))
+}
+
+// SearchIncidents returns one page; pass its Bookmark in the next search for the page after
+func (c *Client) SearchIncidents(ctx context.Context, search IncidentSearch) (*IncidentPage, error) {
+	q := query("from", search.From, "to", search.To, "status", search.Status, "severity", search.Severity,
+		"reporter", search.Reporter, "page_size", pageSize(search.PageSize), "bookmark", search.Bookmark)
+	return fetch[IncidentPage](ctx, c, "/incident", q)
+}
+
+// Incidents yields every incident matching search, fetching pages as the loop reaches them
+func (c *Client) Incidents(ctx context.Context, search IncidentSearch) iter.Seq2[IncidentDocument, error] {
+	return Paginate(ctx, search.Bookmark, func(ctx context.Context, bookmark string) ([]IncidentDocument, string, error) {
+		search.Bookmark = bookmark
+		page, err := c.SearchIncidents(ctx, search)
+		if err != nil {
+			return nil, "", err
+		}
+		return page.Incidents, page.Bookmark, nil
+	})
+}
+
+// CreateEvidence anchors the hash of evidence kept elsewhere
+func (c *Client) CreateEvidence(ctx context.Context, req CreateEvidenceRequest) (*Receipt, error) {
+	return c.write(ctx, http.MethodPost, "/evidence/", req)
+}
+
+func (c *Client) GetEvidence(ctx context.Context, evidenceID string) (*EvidenceDocument, error) {
+	return fetch[EvidenceDocument](ctx, c, "/evidence/"+url.PathEscape(evidenceID), nil)
+}
+
+func (c *Client) UpdateEvidence(ctx context.Context, evidenceID string, req UpdateEvidenceRequest) (*Receipt, error) {
+	return c.write(ctx, http.MethodPut, "/evidence/"+url.PathEscape(evidenceID), req)
+}
+
+func (c *Client) DeleteEvidence(ctx context.Context, evidenceID, actor string) (*Receipt, error) {
+	return c.write(ctx, http.MethodDelete, "/evidence/"+url.PathEscape(evidenceID), actorBody(actor))
+}
+
+// ListEvidence returns an incident's evidence
+func (c *Client) ListEvidence(ctx context.Context, incidentID string, filter EvidenceFilter) ([]EvidenceDocument, error) {
+	q := query("media_type", filter.MediaType, "uploader", filter.UploadedBy, "from", filter.From, "to", filter.To, "sort", filter.Sort)
+	docs, err := fetch[[]EvidenceDocument](ctx, c, "/evidence/incident/"+url.PathEscape(incidentID), q)
+	if err != nil {
+		return nil, err
+	}
+	return *docs, nil
+}
+
+// SearchAudits returns one page; pass its Bookmark in the next search for the page after
+func (c *Client) SearchAudits(ctx context.Context, search AuditSearch) (*AuditPage, error) {
+	q := query("actor", search.Actor, "action", search.Action, "from", search.From, "to", search.To,
+		"page_size", pageSize(search.PageSize), "bookmark", search.Bookmark)
+	return fetch[AuditPage](ctx, c, "/audit", q)
+}
+
+// Audits yields every audit entry matching search, fetching pages as the loop reaches them
+func (c *Client) Audits(ctx context.Context, search AuditSearch) iter.Seq2[AuditDocument, error] {
+	return Paginate(ctx, search.Bookmark, func(ctx context.Context, bookmark string) ([]AuditDocument, string, error) {
+		search.Bookmark = bookmark
+		page, err := c.SearchAudits(ctx, search)
+		if err != nil {
+			return nil, "", err
+		}
+		return page.Audits, page.Bookmark, nil
+	})
+}
+
+// AuditsForTarget returns the audit trail of one DID, incident or evidence item
+func (c *Client) AuditsForTarget(ctx context.Context, targetID string) ([]AuditDocument, error) {
+	docs, err := fetch[[]AuditDocument](ctx, c, "/audit/"+url.PathEscape(targetID), nil)
+	if err != nil {
+		return nil, err
+	}
+	return *docs, nil
+}
+
+// RaiseSOS raises an alert. The receipt is nil while the alert is held for confirmation.
+func (c *Client) RaiseSOS(ctx context.Context, req SOSRequest) (*SOSAlert, *Receipt, error) {
+	var alert SOSAlert
+	receipt, err := c.Do(ctx, Request{Method: http.MethodPost, Path: "/sos", Body: req}, &alert)
+	if err != nil {
+		return nil, nil, err
+	}
+	if alert.Status != "" {
+		receipt = nil
+	}
+	return &alert, receipt, nil
+}
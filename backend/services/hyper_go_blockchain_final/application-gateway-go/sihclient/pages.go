@@ -0,0 +1,38 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sihclient
+
+import (
+	"context"
+	"iter"
+)
+
+// Paginate turns a bookmarked search into a sequence, starting at bookmark, or the first page when
+// it is empty. fetch returns one page and the bookmark of the next, empty after the last. A failed
+// fetch is yielded once and ends the sequence; breaking out of the loop stops fetching.
+func Paginate[T any](ctx context.Context, bookmark string, fetch func(ctx context.Context, bookmark string) ([]T, string, error)) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		for {
+			items, next, err := fetch(ctx, bookmark)
+			if err != nil {
+				var zero T
+				yield(zero, err)
+				return
+			}
+			for _, item := range items {
+				if !yield(item, nil) {
+					return
+				}
+			}
+			// An empty page or a repeated bookmark would otherwise loop forever
+			if next == "" || next == bookmark || len(items) == 0 {
+				return
+			}
+			bookmark = next
+		}
+	}
+}
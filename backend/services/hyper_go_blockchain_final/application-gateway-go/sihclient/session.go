@@ -0,0 +1,121 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sihclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// Session is an access and refresh token pair. The refresh token rotates on every refresh, so a
+// saved session must be replaced by the one passed to Options.OnSession.
+type Session struct {
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type,omitempty"`
+	ExpiresIn        int    `json:"expires_in,omitempty"`
+	RefreshToken     string `json:"refresh_token"`
+	RefreshExpiresAt string `json:"refresh_expires_at"`
+	SessionID        string `json:"session_id"`
+}
+
+// Session returns the current session, or nil when the client sends its API key alone
+func (c *Client) Session() *Session {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.session == nil {
+		return nil
+	}
+	session := *c.session
+	return &session
+}
+
+func (c *Client) accessToken() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.session == nil {
+		return ""
+	}
+	return c.session.AccessToken
+}
+
+func (c *Client) setSession(session *Session) {
+	c.mu.Lock()
+	c.session = session
+	c.mu.Unlock()
+	if c.opts.OnSession != nil {
+		c.opts.OnSession(session)
+	}
+}
+
+// Login trades the API key for a session. DeviceID names a shared device the session is opened
+// on, and may be empty.
+func (c *Client) Login(ctx context.Context, deviceID string) (*Session, error) {
+	if c.opts.APIKey == "" {
+		return nil, errors.New("sihclient: login needs an API key")
+	}
+	var session Session
+	body := struct {
+		DeviceID string `json:"device_id,omitempty"`
+	}{deviceID}
+	// The key alone authenticates a login, never the session it replaces
+	if _, err := c.do(ctx, Request{Method: http.MethodPost, Path: "/auth/token", Body: body}, &session, false); err != nil {
+		return nil, err
+	}
+	c.setSession(&session)
+	return c.Session(), nil
+}
+
+// Refresh rotates the session's tokens. Calls refresh an expired session themselves; gRPC callers
+// use this when a call is refused as unauthenticated.
+func (c *Client) Refresh(ctx context.Context) error {
+	token := c.accessToken()
+	if token == "" {
+		return errors.New("sihclient: no session to refresh")
+	}
+	return c.refresh(ctx, token)
+}
+
+// refresh rotates the session that sent expired, unless another call already has. Concurrent
+// refreshes wait for each other, so a rotated refresh token is never presented twice.
+func (c *Client) refresh(ctx context.Context, expired string) error {
+	c.refreshing.Lock()
+	defer c.refreshing.Unlock()
+	current := c.Session()
+	if current == nil || current.AccessToken != expired {
+		return nil
+	}
+	body, _ := json.Marshal(struct {
+		RefreshToken string `json:"refresh_token"`
+	}{current.RefreshToken})
+	resp, err := c.send(ctx, Request{Method: http.MethodPost, Path: "/auth/refresh"}, body, "")
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		return readError(resp)
+	}
+	var session Session
+	if _, err := decodeEnvelope(resp, &session); err != nil {
+		return err
+	}
+	c.setSession(&session)
+	return nil
+}
+
+// Logout ends the session on the gateway and forgets it
+func (c *Client) Logout(ctx context.Context) error {
+	if c.accessToken() == "" {
+		return nil
+	}
+	if _, err := c.Do(ctx, Request{Method: http.MethodPost, Path: "/auth/logout"}, nil); err != nil {
+		return err
+	}
+	c.setSession(nil)
+	return nil
+}
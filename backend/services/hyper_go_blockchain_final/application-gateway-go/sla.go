@@ -0,0 +1,492 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	slaAcknowledge = "acknowledge"
+	slaResolve     = "resolve"
+
+	// slaEscalationEvent is the notification policy event a breach starts
+	slaEscalationEvent = "sla_breach"
+
+	maxSLAWindowDays = 90
+)
+
+var slaBreachKinds = []string{slaAcknowledge, slaResolve}
+
+// SLABreach is the ledger record of an incident that went unacknowledged or unresolved past its
+// severity's target
+type SLABreach struct {
+	DocType        string `json:"doc_type"`
+	BreachID       string `json:"breach_id"`
+	IncidentID     string `json:"incident_id"`
+	Kind           string `json:"kind"`
+	Severity       string `json:"severity"`
+	District       string `json:"district"`
+	TargetSeconds  int    `json:"target_seconds"`
+	ElapsedSeconds int    `json:"elapsed_seconds"`
+	DetectedBy     string `json:"detected_by"`
+	DetectedAt     string `json:"detected_at"`
+	OwnerOrg       string `json:"owner_org,omitempty"`
+	TxID           string `json:"tx_id"`
+}
+
+// SLABreachListRequest filters recorded breaches
+type SLABreachListRequest struct {
+	Incident string `form:"incident"`
+	District string `form:"district"`
+	Kind     string `form:"kind"`
+}
+
+func (r SLABreachListRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	if r.Incident != "" {
+		v.identifier("incident", r.Incident)
+	}
+	if r.Kind != "" {
+		v.oneOf("kind", r.Kind, slaBreachKinds)
+	}
+	return v.errors
+}
+
+// SLAComplianceRequest selects the incidents reported in the last Days days, by default
+// SLA_COMPLIANCE_DAYS, optionally in one district
+type SLAComplianceRequest struct {
+	Days     int    `form:"days"`
+	District string `form:"district"`
+}
+
+func (r SLAComplianceRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	if r.Days < 0 || r.Days > maxSLAWindowDays {
+		v.add("days", "must be between 1 and %d", maxSLAWindowDays)
+	}
+	return v.errors
+}
+
+// SLAMeasure counts the incidents that met a target and those that breached it. Pending incidents
+// are still open within it and count toward neither; Compliance is Met over Met plus Breached, null
+// while nothing is decided.
+type SLAMeasure struct {
+	Met        int      `json:"met"`
+	Breached   int      `json:"breached"`
+	Pending    int      `json:"pending"`
+	Compliance *float64 `json:"compliance"`
+}
+
+// SLACounts measures a group of incidents against both targets
+type SLACounts struct {
+	Incidents   int        `json:"incidents"`
+	Acknowledge SLAMeasure `json:"acknowledge"`
+	Resolve     SLAMeasure `json:"resolve"`
+}
+
+// SLADistrictCompliance is one district's compliance, overall and by severity. Districts are those of
+// the nearest unit, as on the police dashboard.
+type SLADistrictCompliance struct {
+	District string `json:"district"`
+	SLACounts
+	BySeverity map[string]*SLACounts `json:"by_severity"`
+}
+
+// SLATarget is how long an incident of one severity may take to be acknowledged and resolved
+type SLATarget struct {
+	Acknowledge string `json:"acknowledge,omitempty"`
+	Resolve     string `json:"resolve,omitempty"`
+}
+
+// SLACompliance is the rolling compliance of the incidents reported in [From, To)
+type SLACompliance struct {
+	From      string                  `json:"from"`
+	To        string                  `json:"to"`
+	Targets   map[string]SLATarget    `json:"targets"`
+	Overall   SLACounts               `json:"overall"`
+	Districts []SLADistrictCompliance `json:"districts"`
+}
+
+// slaMonitor holds the targets per severity and, with SLA_MONITOR_ENABLED, sweeps the open incidents
+// on the live board for breaches, recording each on the ledger and escalating it
+type slaMonitor struct {
+	acknowledge map[string]time.Duration
+	resolve     map[string]time.Duration
+	enabled     bool
+	interval    time.Duration
+	days        int
+	actor       string
+
+	mu sync.Mutex
+	// raised maps the breaches this instance has recorded, or found already recorded, to their
+	// incidents, so a sweep does not submit them again
+	raised map[string]string
+}
+
+var slas *slaMonitor
+
+// initSLA runs after initOrchestrator and initPush
+func initSLA() {
+	acknowledge, err := parseSLATargets(getEnv("SLA_ACK_TARGETS", "critical=5m,high=15m,medium=1h,low=4h"))
+	if err != nil {
+		panic(fmt.Errorf("SLA_ACK_TARGETS %w", err))
+	}
+	resolve, err := parseSLATargets(getEnv("SLA_RESOLVE_TARGETS", "critical=2h,high=8h,medium=24h,low=72h"))
+	if err != nil {
+		panic(fmt.Errorf("SLA_RESOLVE_TARGETS %w", err))
+	}
+	slas = &slaMonitor{
+		acknowledge: acknowledge,
+		resolve:     resolve,
+		enabled:     getEnvBool("SLA_MONITOR_ENABLED", false),
+		interval:    getEnvDuration("SLA_CHECK_INTERVAL", time.Minute),
+		days:        getEnvInt("SLA_COMPLIANCE_DAYS", 7),
+		actor:       getEnv("SLA_ACTOR", "sla-monitor"),
+		raised:      map[string]string{},
+	}
+	if slas.interval <= 0 || slas.days <= 0 || slas.days > maxSLAWindowDays {
+		panic(fmt.Errorf("SLA_CHECK_INTERVAL must be positive and SLA_COMPLIANCE_DAYS between 1 and %d", maxSLAWindowDays))
+	}
+	if !slas.enabled {
+		log.Println("⏱️ SLA_MONITOR_ENABLED not set, SLA breaches are not recorded")
+		return
+	}
+	if orchestrator == nil && pusher == nil {
+		log.Println("⏱️ Neither escalation chains nor push are enabled, SLA breaches are only recorded")
+	}
+	log.Printf("⏱️ Monitoring incident SLAs every %s", slas.interval)
+}
+
+func parseSLATargets(value string) (map[string]time.Duration, error) {
+	targets := map[string]time.Duration{}
+	for _, item := range splitList(value) {
+		severity, duration, _ := strings.Cut(item, "=")
+		severity = strings.TrimSpace(severity)
+		if !slices.Contains(incidentSeverities, severity) {
+			return nil, fmt.Errorf("has unknown severity %q", severity)
+		}
+		target, err := time.ParseDuration(strings.TrimSpace(duration))
+		if err != nil || target <= 0 {
+			return nil, fmt.Errorf("has invalid duration for %s", severity)
+		}
+		targets[severity] = target
+	}
+	return targets, nil
+}
+
+// slaBreachID is deterministic, so an incident breaches each target once however many instances
+// notice
+func slaBreachID(incidentID, kind string) string {
+	return "SLA:" + incidentID + ":" + kind
+}
+
+// breachesDue returns the breaches of the open incidents at now. Incidents whose severity has no
+// target are not monitored.
+func (m *slaMonitor) breachesDue(incidents []boardIncident, now time.Time) []SLABreach {
+	var breaches []SLABreach
+	for _, incident := range incidents {
+		created, err := time.Parse(time.RFC3339, incident.CreatedAt)
+		if err != nil {
+			continue
+		}
+		elapsed := now.Sub(created)
+		breach := func(kind string, target time.Duration) {
+			breaches = append(breaches, SLABreach{
+				BreachID: slaBreachID(incident.IncidentID, kind), IncidentID: incident.IncidentID, Kind: kind,
+				Severity: incident.Severity, District: incident.district, OwnerOrg: incident.OwnerOrg,
+				TargetSeconds: int(target.Seconds()), ElapsedSeconds: int(elapsed.Seconds()),
+			})
+		}
+		if target, ok := m.acknowledge[incident.Severity]; ok && incident.AcknowledgedAt == "" && elapsed > target {
+			breach(slaAcknowledge, target)
+		}
+		if target, ok := m.resolve[incident.Severity]; ok && elapsed > target {
+			breach(slaResolve, target)
+		}
+	}
+	sort.Slice(breaches, func(i, j int) bool { return breaches[i].BreachID < breaches[j].BreachID })
+	return breaches
+}
+
+// run sweeps the live board every SLA_CHECK_INTERVAL
+func (m *slaMonitor) run(ctx context.Context) {
+	if !m.enabled {
+		return
+	}
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.sweep(withTarget(ctx, defaultTarget), time.Now())
+		}
+	}
+}
+
+// sweep records and escalates the breaches not raised yet, and forgets the incidents that have left
+// the board
+func (m *slaMonitor) sweep(ctx context.Context, now time.Time) {
+	incidents := liveBoard.snapshot()
+	open := map[string]bool{}
+	for _, incident := range incidents {
+		open[incident.IncidentID] = true
+	}
+	m.mu.Lock()
+	for id, incidentID := range m.raised {
+		if !open[incidentID] {
+			delete(m.raised, id)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, breach := range m.breachesDue(incidents, now) {
+		m.mu.Lock()
+		_, raised := m.raised[breach.BreachID]
+		m.mu.Unlock()
+		if !raised {
+			m.raise(ctx, breach)
+		}
+	}
+}
+
+// raise records a breach on the ledger, which adds an SLA_BREACH audit entry to the incident, and
+// escalates it. The ledger rejects a breach recorded before, or one the incident has since moved
+// past, and either is not tried again.
+func (m *slaMonitor) raise(ctx context.Context, breach SLABreach) {
+	result, err := submitTransaction(ctx, "RecordSLABreach", breach.BreachID, breach.IncidentID, breach.Kind, breach.District,
+		strconv.Itoa(breach.TargetSeconds), strconv.Itoa(breach.ElapsedSeconds), m.actor)
+	if err != nil {
+		if status := translateFabricError(err).Status; status < http.StatusInternalServerError {
+			m.markRaised(breach)
+			return
+		}
+		log.Printf("⏱️ Failed to record SLA breach %s: %v", breach.BreachID, err)
+		return
+	}
+	m.markRaised(breach)
+	log.Printf("⏱️ %s incident %s breached its %s target of %s in transaction %s", breach.Severity, breach.IncidentID, breach.Kind,
+		time.Duration(breach.TargetSeconds)*time.Second, result.TxID)
+	m.escalate(ctx, breach)
+}
+
+func (m *slaMonitor) markRaised(breach SLABreach) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.raised[breach.BreachID] = breach.IncidentID
+}
+
+// escalate routes a breach through its escalation chain, or pushes it to the incident organization's
+// devices when no chain matches
+func (m *slaMonitor) escalate(ctx context.Context, breach SLABreach) {
+	incident, _ := ledger.GetIncident(ctx, breach.IncidentID)
+	verb := "acknowledged"
+	if breach.Kind == slaResolve {
+		verb = "resolved"
+	}
+	title := strings.TrimSpace("SLA breach: " + breach.Severity + " incident not " + verb)
+	message := fmt.Sprintf("Incident %s in %s has not been %s after %s; the target is %s", breach.IncidentID, breach.District, verb,
+		(time.Duration(breach.ElapsedSeconds) * time.Second).Round(time.Minute), time.Duration(breach.TargetSeconds)*time.Second)
+
+	if orchestrator.handles(slaEscalationEvent, incident.Category, breach.Severity) {
+		c := EscalationCase{
+			Event: slaEscalationEvent, SubjectID: breach.BreachID, Category: incident.Category, Severity: breach.Severity,
+			Title: title, Message: message,
+		}
+		if lat, lng := geohashCenter(incident.Geohash); lat != nil {
+			c.MapURL = fmt.Sprintf("https://maps.google.com/?q=%.6f,%.6f", lat, lng)
+		}
+		orchestrator.start(ctx, c)
+		return
+	}
+	if pusher != nil {
+		pusher.deliver(ctx, pushAlert{
+			key:   "sla:" + breach.BreachID,
+			org:   breach.OwnerOrg,
+			title: title,
+			body:  message,
+			data:  map[string]string{"type": "sla_breach", "breach_id": breach.BreachID, "incident_id": breach.IncidentID, "kind": breach.Kind, "severity": breach.Severity},
+		})
+	}
+}
+
+// measureSLA counts one incident against a target: met or breached once the step was taken, and
+// breached or pending while it is not
+func measureSLA(m *SLAMeasure, start, end string, target time.Duration, now time.Time) {
+	created, err := time.Parse(time.RFC3339, start)
+	if err != nil {
+		return
+	}
+	done, err := time.Parse(time.RFC3339, end)
+	switch {
+	case err == nil && done.Sub(created) <= target:
+		m.Met++
+	case err == nil || now.Sub(created) > target:
+		m.Breached++
+	default:
+		m.Pending++
+	}
+}
+
+func (m *SLAMeasure) finish() {
+	if decided := m.Met + m.Breached; decided > 0 {
+		compliance := float64(m.Met) / float64(decided)
+		m.Compliance = &compliance
+	}
+}
+
+func (c *SLACounts) add(incident IncidentDocument, acknowledge, resolve time.Duration, now time.Time) {
+	c.Incidents++
+	measureSLA(&c.Acknowledge, incident.CreatedAt, incident.AcknowledgedAt, acknowledge, now)
+	measureSLA(&c.Resolve, incident.CreatedAt, incident.ResolvedAt, resolve, now)
+}
+
+func (c *SLACounts) finish() {
+	c.Acknowledge.finish()
+	c.Resolve.finish()
+}
+
+// compliance measures incidents against their severity's targets by district. Merged duplicates and
+// incidents whose severity has no targets are left out.
+func (m *slaMonitor) compliance(incidents []IncidentDocument, district string, now time.Time) ([]SLADistrictCompliance, SLACounts) {
+	var overall SLACounts
+	districts := map[string]*SLADistrictCompliance{}
+	for _, incident := range incidents {
+		acknowledge, hasAck := m.acknowledge[incident.Severity]
+		resolve, hasResolve := m.resolve[incident.Severity]
+		if incident.MergedInto != "" || !hasAck || !hasResolve {
+			continue
+		}
+		name := districtOf(incident.Geohash)
+		if district != "" && name != district {
+			continue
+		}
+		d := districts[name]
+		if d == nil {
+			d = &SLADistrictCompliance{District: name, BySeverity: map[string]*SLACounts{}}
+			districts[name] = d
+		}
+		severity := d.BySeverity[incident.Severity]
+		if severity == nil {
+			severity = &SLACounts{}
+			d.BySeverity[incident.Severity] = severity
+		}
+		for _, counts := range []*SLACounts{&overall, &d.SLACounts, severity} {
+			counts.add(incident, acknowledge, resolve, now)
+		}
+	}
+
+	result := []SLADistrictCompliance{}
+	for _, name := range sortedKeys(districts) {
+		d := districts[name]
+		d.finish()
+		for _, counts := range d.BySeverity {
+			counts.finish()
+		}
+		result = append(result, *d)
+	}
+	overall.finish()
+	return result, overall
+}
+
+// Compliance measures the incidents the caller's organization reported in the last days against
+// their targets
+func (m *slaMonitor) Compliance(ctx context.Context, req SLAComplianceRequest) (SLACompliance, error) {
+	if errs := req.Validate(); len(errs) > 0 {
+		return SLACompliance{}, errs
+	}
+	days := req.Days
+	if days == 0 {
+		days = m.days
+	}
+	now := time.Now().UTC()
+	from := now.AddDate(0, 0, -days)
+	incidents, err := ledgerReportSource{}.incidents(ctx, from, now)
+	if err != nil {
+		return SLACompliance{}, err
+	}
+
+	result := SLACompliance{From: from.Format(time.RFC3339), To: now.Format(time.RFC3339), Targets: map[string]SLATarget{}}
+	for _, severity := range incidentSeverities {
+		var target SLATarget
+		if d, ok := m.acknowledge[severity]; ok {
+			target.Acknowledge = d.String()
+		}
+		if d, ok := m.resolve[severity]; ok {
+			target.Resolve = d.String()
+		}
+		if target != (SLATarget{}) {
+			result.Targets[severity] = target
+		}
+	}
+	result.Districts, result.Overall = m.compliance(incidents, req.District, now)
+	return result, nil
+}
+
+// ListSLABreaches returns the recorded breaches of the caller's organization's incidents, latest
+// first
+func (ledgerService) ListSLABreaches(ctx context.Context, req SLABreachListRequest) ([]SLABreach, error) {
+	if errs := req.Validate(); len(errs) > 0 {
+		return nil, errs
+	}
+	result, err := evaluateTransaction(ctx, "QuerySLABreaches", req.Incident, req.District, req.Kind)
+	if err != nil {
+		return nil, err
+	}
+	breaches, err := decodeDocument[[]SLABreach](result, "SLA breach")
+	if err != nil {
+		return nil, err
+	}
+	t := tenantFromContext(ctx)
+	visible := []SLABreach{}
+	for _, breach := range breaches {
+		if t.owns(breach.OwnerOrg) {
+			visible = append(visible, breach)
+		}
+	}
+	sort.Slice(visible, func(i, j int) bool { return visible[i].DetectedAt > visible[j].DetectedAt })
+	return visible, nil
+}
+
+func getSLACompliance(c *gin.Context) {
+	var req SLAComplianceRequest
+	if !bindQuery(c, &req) {
+		return
+	}
+	compliance, err := slas.Compliance(c.Request.Context(), req)
+	if err != nil {
+		respondServiceError(c, "Failed to measure SLA compliance", err)
+		return
+	}
+	respondData(c, http.StatusOK, compliance)
+}
+
+func listSLABreaches(c *gin.Context) {
+	var req SLABreachListRequest
+	if !bindQuery(c, &req) {
+		return
+	}
+	breaches, err := ledger.ListSLABreaches(c.Request.Context(), req)
+	if err != nil {
+		respondServiceError(c, "Failed to list SLA breaches", err)
+		return
+	}
+	respondData(c, http.StatusOK, breaches)
+}
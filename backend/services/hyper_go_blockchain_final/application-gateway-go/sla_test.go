@@ -0,0 +1,104 @@
+package gateway
+
+import (
+	"testing"
+	"time"
+)
+
+func testSLAMonitor(t *testing.T) *slaMonitor {
+	acknowledge, err := parseSLATargets("critical=5m, high=15m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolve, err := parseSLATargets("critical=2h,high=8h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &slaMonitor{acknowledge: acknowledge, resolve: resolve, raised: map[string]string{}}
+}
+
+func TestParseSLATargets(t *testing.T) {
+	for _, value := range []string{"urgent=5m", "high=soon", "high=-5m"} {
+		if _, err := parseSLATargets(value); err == nil {
+			t.Errorf("expected %q rejected", value)
+		}
+	}
+}
+
+func TestSLABreachesDue(t *testing.T) {
+	m := testSLAMonitor(t)
+	now := time.Date(2025, 9, 20, 15, 0, 0, 0, time.UTC)
+	at := func(ago time.Duration) string { return now.Add(-ago).Format(time.RFC3339) }
+	incidents := []boardIncident{
+		{IncidentDocument: IncidentDocument{IncidentID: "INC-1", Severity: "critical", CreatedAt: at(10 * time.Minute)}, district: "east-khasi-hills"},
+		{IncidentDocument: IncidentDocument{IncidentID: "INC-2", Severity: "critical", CreatedAt: at(3 * time.Hour), AcknowledgedAt: at(2 * time.Hour)}},
+		{IncidentDocument: IncidentDocument{IncidentID: "INC-3", Severity: "high", CreatedAt: at(10 * time.Minute)}},
+		// No target for low incidents
+		{IncidentDocument: IncidentDocument{IncidentID: "INC-4", Severity: "low", CreatedAt: at(48 * time.Hour)}},
+	}
+
+	breaches := m.breachesDue(incidents, now)
+	if len(breaches) != 2 {
+		t.Fatalf("expected two breaches, got %+v", breaches)
+	}
+	if b := breaches[0]; b.BreachID != "SLA:INC-1:acknowledge" || b.District != "east-khasi-hills" || b.TargetSeconds != 300 || b.ElapsedSeconds != 600 {
+		t.Errorf("unexpected acknowledgement breach %+v", b)
+	}
+	if b := breaches[1]; b.BreachID != "SLA:INC-2:resolve" || b.TargetSeconds != 7200 {
+		t.Errorf("unexpected resolution breach %+v", b)
+	}
+}
+
+func TestSLACompliance(t *testing.T) {
+	previous := policeUnits
+	policeUnits = []PoliceUnit{{ID: "shillong", District: "east-khasi-hills", Latitude: 25.5788, Longitude: 91.8933}}
+	defer func() { policeUnits = previous }()
+
+	m := testSLAMonitor(t)
+	now := time.Date(2025, 9, 20, 15, 0, 0, 0, time.UTC)
+	at := func(ago time.Duration) string { return now.Add(-ago).Format(time.RFC3339) }
+	shillong := encodeGeohash(25.57, 91.88, ledgerGeohashPrecision)
+	incidents := []IncidentDocument{
+		// Acknowledged and resolved in time
+		{IncidentID: "INC-1", Severity: "critical", Geohash: shillong, CreatedAt: at(5 * time.Hour), AcknowledgedAt: at(5*time.Hour - 2*time.Minute), ResolvedAt: at(4 * time.Hour)},
+		// Acknowledged late, still open within its resolution target
+		{IncidentID: "INC-2", Severity: "high", Geohash: shillong, CreatedAt: at(time.Hour), AcknowledgedAt: at(30 * time.Minute)},
+		// Not acknowledged yet, still within its target
+		{IncidentID: "INC-3", Severity: "critical", Geohash: shillong, CreatedAt: at(time.Minute)},
+		{IncidentID: "INC-4", Severity: "critical", CreatedAt: at(3 * time.Hour)},
+		{IncidentID: "INC-5", Severity: "critical", Geohash: shillong, CreatedAt: at(time.Hour), MergedInto: "INC-1"},
+		{IncidentID: "INC-6", Severity: "low", Geohash: shillong, CreatedAt: at(time.Hour)},
+	}
+
+	districts, overall := m.compliance(incidents, "", now)
+	if overall.Incidents != 4 || len(districts) != 2 || districts[0].District != "east-khasi-hills" || districts[1].District != unassignedDistrict {
+		t.Fatalf("unexpected compliance %+v %+v", overall, districts)
+	}
+	d := districts[0]
+	if d.Acknowledge.Met != 1 || d.Acknowledge.Breached != 1 || d.Acknowledge.Pending != 1 || *d.Acknowledge.Compliance != 0.5 {
+		t.Errorf("unexpected acknowledgements %+v", d.Acknowledge)
+	}
+	if d.Resolve.Met != 1 || d.Resolve.Breached != 0 || d.Resolve.Pending != 2 || *d.Resolve.Compliance != 1 {
+		t.Errorf("unexpected resolutions %+v", d.Resolve)
+	}
+	if high := d.BySeverity["high"]; high.Incidents != 1 || *high.Acknowledge.Compliance != 0 || high.Resolve.Compliance != nil {
+		t.Errorf("unexpected high severity counts %+v", high)
+	}
+	if unassigned := districts[1]; unassigned.Acknowledge.Breached != 1 || unassigned.Resolve.Breached != 1 {
+		t.Errorf("expected the unacknowledged incident to breach both targets, got %+v", unassigned)
+	}
+
+	if only, _ := m.compliance(incidents, unassignedDistrict, now); len(only) != 1 || only[0].Incidents != 1 {
+		t.Errorf("expected only the unassigned district, got %+v", only)
+	}
+}
+
+func TestSLABreachPolicyTargets(t *testing.T) {
+	policy := `{"rules": [{"event": "sla_breach", "chain": [{"target": "contact:1", "channels": ["sms"]}]}]}`
+	if _, err := parseNotificationPolicy([]byte(policy)); err == nil {
+		t.Error("expected a breach chain notifying a contact rejected")
+	}
+	if policy, err := parseNotificationPolicy([]byte(defaultNotificationPolicy)); err != nil || policy.match(slaEscalationEvent, "theft", "low") == nil {
+		t.Errorf("expected the default policy to escalate breaches, got %v", err)
+	}
+}
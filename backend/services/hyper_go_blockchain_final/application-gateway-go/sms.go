@@ -0,0 +1,500 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SMS delivery states. Provider callbacks move a message forward; a late "sent" report never
+// overwrites "delivered" or "failed".
+const (
+	smsQueued    = "queued"
+	smsSent      = "sent"
+	smsDelivered = "delivered"
+	smsFailed    = "failed"
+
+	smsRecordPrefix    = "sih:sms:"
+	smsReferencePrefix = "sih:sms:ref:"
+
+	errCodeSMSDisabled = "SMS_DISABLED"
+)
+
+var smsStatusRank = map[string]int{smsQueued: 0, smsSent: 1, smsDelivered: 2, smsFailed: 2}
+
+// defaultSMSTemplates are used unless SMS_TEMPLATES_DIR has a <name>.tmpl of the same name. Keep
+// them within one 160-character segment where possible; DLT-registered templates must match the
+// rendered text exactly.
+var defaultSMSTemplates = map[string]string{
+	"sos":                  `SOS: {{.DigitalID}} needs help.{{if .PoliceUnit}} {{.PoliceUnit}}{{if .PolicePhone}} ({{.PolicePhone}}){{end}} has been alerted.{{end}} Location: {{.MapURL}} Ref {{.Reference}}`,
+	"escalation":           `{{.Message}}.{{if .MapURL}} Location: {{.MapURL}}{{end}} Ref {{.Reference}}`,
+	"missing_person":       `Dear {{.RecipientName}}, tourist {{.DigitalID}} has been reported missing. Police case {{.Reference}}. Please call 112 with any information.`,
+	"contact_verification": `{{.Code}} is your code to confirm you are an emergency contact of tourist {{.DigitalID}}. Do not share it. Ref {{.Reference}}`,
+}
+
+// SMSRecord tracks one message through delivery. The number is masked so records can be listed
+// without exposing contacts' phone numbers.
+type SMSRecord struct {
+	MessageID string `json:"message_id"`
+	Provider  string `json:"provider"`
+	To        string `json:"to"`
+	Template  string `json:"template"`
+	Reference string `json:"reference"`
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+	SentAt    string `json:"sent_at"`
+	UpdatedAt string `json:"updated_at"`
+	// Org is the organization whose request sent the message
+	Org string `json:"org,omitempty"`
+}
+
+// SMSStatusRequest lists the messages sent for an SOS alert or incident
+type SMSStatusRequest struct {
+	Reference string `form:"reference" binding:"required"`
+}
+
+func (r SMSStatusRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.identifier("reference", r.Reference)
+	return v.errors
+}
+
+// smsData is what templates can refer to
+type smsData struct {
+	RecipientName string
+	DigitalID     string
+	Reference     string
+	MapURL        string
+	PoliceUnit    string
+	PolicePhone   string
+	Time          string
+	Message       string
+	Code          string
+}
+
+type smsMessage struct {
+	To         string // E.164
+	Body       string
+	TemplateID string // DLT template ID, required by Indian operators
+}
+
+type smsStatusUpdate struct {
+	MessageID string
+	Status    string
+	Error     string
+}
+
+// smsProvider sends messages through one SMS aggregator and reads its delivery reports
+type smsProvider interface {
+	name() string
+	send(ctx context.Context, msg smsMessage) (messageID string, err error)
+	// callback authenticates a delivery report and returns the statuses it carries
+	callback(r *http.Request) ([]smsStatusUpdate, error)
+}
+
+var errSMSCallbackUnauthorized = errors.New("delivery report failed authentication")
+
+// smsSender renders templates, sends through the configured provider and tracks delivery
+type smsSender struct {
+	provider    smsProvider
+	templates   *template.Template
+	templateIDs map[string]string
+	countryCode string
+	store       smsStatusStore
+	ttl         time.Duration
+}
+
+var smsGateway *smsSender
+
+// initSMS configures the provider named by SMS_PROVIDER. Runs after initDocumentCache so delivery
+// status can be shared through Redis. Without it, SOS text messages go through NOTIFY_WEBHOOK_URL.
+func initSMS() {
+	kind := getEnv("SMS_PROVIDER", "")
+	if kind == "" {
+		log.Println("💬 SMS_PROVIDER not set, SMS handled by the notification webhook")
+		return
+	}
+
+	httpClient := &http.Client{Timeout: getEnvDuration("SMS_TIMEOUT", 10*time.Second)}
+	callbackToken := getEnv("SMS_CALLBACK_TOKEN", "")
+	var provider smsProvider
+	switch kind {
+	case "twilio":
+		provider = &twilioSMS{
+			endpoint:    strings.TrimSuffix(getEnv("TWILIO_ENDPOINT", "https://api.twilio.com"), "/"),
+			accountSID:  getEnv("TWILIO_ACCOUNT_SID", ""),
+			authToken:   getEnv("TWILIO_AUTH_TOKEN", ""),
+			from:        getEnv("TWILIO_FROM", ""),
+			callbackURL: getEnv("SMS_CALLBACK_URL", ""),
+			httpClient:  httpClient,
+		}
+	case "msg91":
+		provider = &msg91SMS{
+			endpoint:      strings.TrimSuffix(getEnv("MSG91_ENDPOINT", "https://api.msg91.com"), "/"),
+			authKey:       getEnv("MSG91_AUTH_KEY", ""),
+			sender:        getEnv("MSG91_SENDER_ID", ""),
+			route:         getEnv("MSG91_ROUTE", "4"),
+			callbackToken: callbackToken,
+			httpClient:    httpClient,
+		}
+	case "state":
+		provider = &stateSMS{
+			endpoint:      getEnv("STATE_SMS_ENDPOINT", "https://msdgweb.mgov.gov.in/esms/sendsmsrequestDLT"),
+			username:      getEnv("STATE_SMS_USERNAME", ""),
+			password:      getEnv("STATE_SMS_PASSWORD", ""),
+			senderID:      getEnv("STATE_SMS_SENDER_ID", ""),
+			secureKey:     getEnv("STATE_SMS_SECURE_KEY", ""),
+			callbackToken: callbackToken,
+			httpClient:    httpClient,
+		}
+	default:
+		panic(fmt.Errorf("unknown SMS_PROVIDER %q, expected twilio, msg91 or state", kind))
+	}
+
+	templates, err := loadSMSTemplates(getEnv("SMS_TEMPLATES_DIR", ""))
+	if err != nil {
+		panic(fmt.Errorf("failed to load SMS templates: %w", err))
+	}
+	var store smsStatusStore = newMemorySMSStore()
+	if documentCache != nil {
+		store = redisSMSStore{documentCache}
+	}
+	smsGateway = &smsSender{
+		provider:    provider,
+		templates:   templates,
+		templateIDs: parseKeyValues(getEnv("SMS_DLT_TEMPLATE_IDS", "")),
+		countryCode: getEnv("SMS_DEFAULT_COUNTRY_CODE", "+91"),
+		store:       store,
+		ttl:         getEnvDuration("SMS_STATUS_TTL", 7*24*time.Hour),
+	}
+	log.Printf("💬 Sending SMS through %s", kind)
+}
+
+func loadSMSTemplates(dir string) (*template.Template, error) {
+	templates := template.New("sms").Option("missingkey=error")
+	for name, source := range defaultSMSTemplates {
+		if dir != "" {
+			if data, err := os.ReadFile(filepath.Join(dir, name+".tmpl")); err == nil {
+				source = strings.TrimSpace(string(data))
+			} else if !errors.Is(err, os.ErrNotExist) {
+				return nil, err
+			}
+		}
+		if _, err := templates.New(name).Parse(source); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return templates, nil
+}
+
+// send renders the named template and sends it to one number, recording the message under
+// reference for status lookups
+func (s *smsSender) send(ctx context.Context, templateName, to, reference string, data smsData) (*SMSRecord, error) {
+	number, err := normalizeMobile(to, s.countryCode)
+	if err != nil {
+		return nil, err
+	}
+	var body strings.Builder
+	data.Reference = reference
+	if data.Time == "" {
+		data.Time = time.Now().UTC().Format(time.RFC3339)
+	}
+	if err := s.templates.ExecuteTemplate(&body, templateName, data); err != nil {
+		return nil, err
+	}
+
+	messageID, err := s.provider.send(ctx, smsMessage{To: number, Body: body.String(), TemplateID: s.templateIDs[templateName]})
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	record := &SMSRecord{
+		MessageID: messageID,
+		Provider:  s.provider.name(),
+		To:        maskMobile(number),
+		Template:  templateName,
+		Reference: reference,
+		Status:    smsQueued,
+		SentAt:    now,
+		UpdatedAt: now,
+		Org:       tenantFromContext(ctx).org,
+	}
+	if err := s.store.put(context.WithoutCancel(ctx), *record, s.ttl); err != nil {
+		logWithContext(ctx, "Failed to record SMS %s: %v", messageID, err)
+	}
+	return record, nil
+}
+
+// notifyMissingPerson texts the emergency contacts of a tourist reported missing. It runs after
+// the incident is committed and only logs failures, since the incident itself was recorded.
+func notifyMissingPerson(ctx context.Context, incidentID, digitalID string) {
+	if smsGateway == nil || emergencyContacts == nil {
+		return
+	}
+	contacts, err := emergencyContacts.contacts(ctx, digitalID)
+	if err != nil {
+		logWithContext(ctx, "Failed to resolve emergency contacts for missing person %s: %v", incidentID, err)
+		return
+	}
+	for _, contact := range contacts {
+		if contact.Phone == "" {
+			continue
+		}
+		data := smsData{RecipientName: contact.Name, DigitalID: digitalID}
+		if _, err := smsGateway.send(ctx, "missing_person", contact.Phone, incidentID, data); err != nil {
+			logWithContext(ctx, "Failed to text %s about missing person %s: %v", contact.Name, incidentID, err)
+		}
+	}
+}
+
+// normalizeMobile returns the number in E.164 form, assuming countryCode for national numbers
+func normalizeMobile(number, countryCode string) (string, error) {
+	var digits strings.Builder
+	for i, r := range strings.TrimSpace(number) {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r == '+' && i == 0:
+			digits.WriteRune(r)
+		case r == ' ' || r == '-' || r == '(' || r == ')':
+		default:
+			return "", fmt.Errorf("invalid phone number %q", maskMobile(number))
+		}
+	}
+	normalized := digits.String()
+	switch {
+	case strings.HasPrefix(normalized, "+"):
+	case strings.HasPrefix(normalized, "00"):
+		normalized = "+" + normalized[2:]
+	default:
+		normalized = countryCode + strings.TrimPrefix(normalized, "0")
+	}
+	if len(normalized) < 8 || len(normalized) > 16 {
+		return "", fmt.Errorf("invalid phone number %q", maskMobile(number))
+	}
+	return normalized, nil
+}
+
+func maskMobile(number string) string {
+	if len(number) <= 4 {
+		return strings.Repeat("*", len(number))
+	}
+	return strings.Repeat("*", len(number)-4) + number[len(number)-4:]
+}
+
+func validCallbackToken(r *http.Request, token string) bool {
+	return token != "" && subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("token")), []byte(token)) == 1
+}
+
+// smsStatusStore keeps delivery records in Redis when the document cache is configured, so a
+// callback received by any gateway instance is visible to all of them, and in memory otherwise
+type smsStatusStore interface {
+	put(ctx context.Context, record SMSRecord, ttl time.Duration) error
+	update(ctx context.Context, provider string, update smsStatusUpdate) error
+	byReference(ctx context.Context, reference string) ([]SMSRecord, error)
+}
+
+// advance applies a delivery report to a record, reporting whether it changed
+func (r *SMSRecord) advance(update smsStatusUpdate) bool {
+	if smsStatusRank[update.Status] < smsStatusRank[r.Status] || (update.Status == r.Status && update.Error == r.Error) {
+		return false
+	}
+	r.Status, r.Error, r.UpdatedAt = update.Status, update.Error, time.Now().UTC().Format(time.RFC3339)
+	return true
+}
+
+type redisSMSStore struct {
+	redis *redisClient
+}
+
+func smsRecordKey(provider, messageID string) string {
+	return smsRecordPrefix + provider + ":" + messageID
+}
+
+func (s redisSMSStore) put(ctx context.Context, record SMSRecord, ttl time.Duration) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	key := smsRecordKey(record.Provider, record.MessageID)
+	if err := s.redis.Set(ctx, key, data, ttl); err != nil {
+		return err
+	}
+	refKey := smsReferencePrefix + record.Reference
+	if _, err := s.redis.Do(ctx, "SADD", refKey, key); err != nil {
+		return err
+	}
+	_, err = s.redis.Do(ctx, "PEXPIRE", refKey, fmt.Sprint(ttl.Milliseconds()))
+	return err
+}
+
+func (s redisSMSStore) update(ctx context.Context, provider string, update smsStatusUpdate) error {
+	key := smsRecordKey(provider, update.MessageID)
+	data, err := s.redis.Get(ctx, key)
+	if errors.Is(err, errRedisNil) {
+		// Reports for messages sent before a restart of an in-memory deployment, or expired ones
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var record SMSRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return err
+	}
+	if !record.advance(update) {
+		return nil
+	}
+	if data, err = json.Marshal(record); err != nil {
+		return err
+	}
+	_, err = s.redis.Do(ctx, "SET", key, string(data), "KEEPTTL")
+	return err
+}
+
+func (s redisSMSStore) byReference(ctx context.Context, reference string) ([]SMSRecord, error) {
+	reply, err := s.redis.Do(ctx, "SMEMBERS", smsReferencePrefix+reference)
+	if err != nil {
+		return nil, err
+	}
+	members, _ := reply.([]interface{})
+	records := make([]SMSRecord, 0, len(members))
+	for _, member := range members {
+		key, _ := member.([]byte)
+		data, err := s.redis.Get(ctx, string(key))
+		if errors.Is(err, errRedisNil) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var record SMSRecord
+		if err := json.Unmarshal(data, &record); err == nil {
+			records = append(records, record)
+		}
+	}
+	sortSMSRecords(records)
+	return records, nil
+}
+
+func sortSMSRecords(records []SMSRecord) {
+	sort.SliceStable(records, func(i, j int) bool { return records[i].SentAt < records[j].SentAt })
+}
+
+// memorySMSStore serves a single gateway instance; records are dropped once they expire
+type memorySMSStore struct {
+	mu      sync.Mutex
+	records map[string]memorySMSEntry
+	order   []string
+}
+
+type memorySMSEntry struct {
+	record  SMSRecord
+	expires time.Time
+}
+
+func newMemorySMSStore() *memorySMSStore {
+	return &memorySMSStore{records: map[string]memorySMSEntry{}}
+}
+
+func (s *memorySMSStore) put(_ context.Context, record SMSRecord, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	// Records are added in expiry order, so expired ones are always at the front
+	for len(s.order) > 0 && !now.Before(s.records[s.order[0]].expires) {
+		delete(s.records, s.order[0])
+		s.order = s.order[1:]
+	}
+	key := smsRecordKey(record.Provider, record.MessageID)
+	s.records[key] = memorySMSEntry{record: record, expires: now.Add(ttl)}
+	s.order = append(s.order, key)
+	return nil
+}
+
+func (s *memorySMSStore) update(_ context.Context, provider string, update smsStatusUpdate) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := smsRecordKey(provider, update.MessageID)
+	if entry, ok := s.records[key]; ok && entry.record.advance(update) {
+		s.records[key] = entry
+	}
+	return nil
+}
+
+func (s *memorySMSStore) byReference(_ context.Context, reference string) ([]SMSRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	records := []SMSRecord{}
+	for _, key := range s.order {
+		if entry, ok := s.records[key]; ok && entry.record.Reference == reference && now.Before(entry.expires) {
+			records = append(records, entry.record)
+		}
+	}
+	return records, nil
+}
+
+// smsCallback receives delivery reports. It sits outside /api/v1 because providers cannot send
+// API keys; each provider authenticates its reports instead.
+func smsCallback(c *gin.Context) {
+	if smsGateway == nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	updates, err := smsGateway.provider.callback(c.Request)
+	if errors.Is(err, errSMSCallbackUnauthorized) {
+		c.Status(http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		log.Printf("💬 Invalid %s delivery report: %v", smsGateway.provider.name(), err)
+		c.Status(http.StatusBadRequest)
+		return
+	}
+	for _, update := range updates {
+		if err := smsGateway.store.update(c.Request.Context(), smsGateway.provider.name(), update); err != nil {
+			log.Printf("💬 Failed to record delivery report for %s: %v", update.MessageID, err)
+			c.Status(http.StatusServiceUnavailable)
+			return
+		}
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func listSMSMessages(c *gin.Context) {
+	if smsGateway == nil {
+		respondError(c, http.StatusServiceUnavailable, errCodeSMSDisabled, "SMS is not configured")
+		return
+	}
+	var req SMSStatusRequest
+	if !bindQuery(c, &req) {
+		return
+	}
+
+	records, err := smsGateway.store.byReference(c.Request.Context(), req.Reference)
+	if err != nil {
+		respondServiceError(c, "Failed to list SMS messages", err)
+		return
+	}
+	respondData(c, http.StatusOK, filterOwned(tenantFromContext(c.Request.Context()), records, func(r SMSRecord) string { return r.Org }))
+}
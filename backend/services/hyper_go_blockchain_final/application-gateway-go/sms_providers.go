@@ -0,0 +1,285 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+func smsResponseError(provider string, resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return fmt.Errorf("%s responded with %d: %s", provider, resp.StatusCode, strings.TrimSpace(string(body)))
+}
+
+// twilioSMS sends through the Twilio Messages API. Delivery reports are posted to
+// SMS_CALLBACK_URL and verified with the X-Twilio-Signature header.
+type twilioSMS struct {
+	endpoint    string
+	accountSID  string
+	authToken   string
+	from        string // a number, or a Messaging Service SID starting with MG
+	callbackURL string
+	httpClient  *http.Client
+}
+
+func (t *twilioSMS) name() string { return "twilio" }
+
+func (t *twilioSMS) send(ctx context.Context, msg smsMessage) (string, error) {
+	form := url.Values{"To": {msg.To}, "Body": {msg.Body}}
+	if strings.HasPrefix(t.from, "MG") {
+		form.Set("MessagingServiceSid", t.from)
+	} else {
+		form.Set("From", t.from)
+	}
+	if t.callbackURL != "" {
+		form.Set("StatusCallback", t.callbackURL)
+	}
+
+	target := fmt.Sprintf("%s/2010-04-01/Accounts/%s/Messages.json", t.endpoint, url.PathEscape(t.accountSID))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(t.accountSID, t.authToken)
+	resp, err := t.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return "", smsResponseError("Twilio", resp)
+	}
+
+	var out struct {
+		SID string `json:"sid"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return "", err
+	}
+	return out.SID, nil
+}
+
+func (t *twilioSMS) callback(r *http.Request) ([]smsStatusUpdate, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+	if t.callbackURL == "" || !hmac.Equal([]byte(r.Header.Get("X-Twilio-Signature")), []byte(twilioSignature(t.authToken, t.callbackURL, r.PostForm))) {
+		return nil, errSMSCallbackUnauthorized
+	}
+
+	update := smsStatusUpdate{MessageID: r.PostForm.Get("MessageSid")}
+	switch r.PostForm.Get("MessageStatus") {
+	case "delivered", "read":
+		update.Status = smsDelivered
+	case "undelivered", "failed":
+		update.Status, update.Error = smsFailed, "error code "+r.PostForm.Get("ErrorCode")
+	case "sent":
+		update.Status = smsSent
+	default:
+		update.Status = smsQueued
+	}
+	return []smsStatusUpdate{update}, nil
+}
+
+// twilioSignature is Twilio's request signature: an HMAC-SHA1 of the URL followed by each POST
+// parameter name and value, sorted by name. Voice webhooks are signed the same way.
+func twilioSignature(authToken, callbackURL string, params url.Values) string {
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	mac := hmac.New(sha1.New, []byte(authToken))
+	io.WriteString(mac, callbackURL)
+	for _, name := range names {
+		for _, value := range params[name] {
+			io.WriteString(mac, name+value)
+		}
+	}
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// msg91SMS sends through the MSG91 SMS API. Delivery reports configured in the MSG91 panel are
+// posted to SMS_CALLBACK_URL with ?token=SMS_CALLBACK_TOKEN.
+type msg91SMS struct {
+	endpoint      string
+	authKey       string
+	sender        string
+	route         string
+	callbackToken string
+	httpClient    *http.Client
+}
+
+func (m *msg91SMS) name() string { return "msg91" }
+
+func (m *msg91SMS) send(ctx context.Context, msg smsMessage) (string, error) {
+	payload := map[string]interface{}{
+		"sender": m.sender,
+		"route":  m.route,
+		"sms":    []map[string]interface{}{{"message": msg.Body, "to": []string{strings.TrimPrefix(msg.To, "+")}}},
+	}
+	if msg.TemplateID != "" {
+		payload["DLT_TE_ID"] = msg.TemplateID
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.endpoint+"/api/v2/sendsms", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("authkey", m.authKey)
+	resp, err := m.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", smsResponseError("MSG91", resp)
+	}
+
+	// The request ID doubles as the message ID in delivery reports
+	var out struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return "", err
+	}
+	if out.Type != "success" {
+		return "", fmt.Errorf("MSG91 rejected the message: %s", out.Message)
+	}
+	return out.Message, nil
+}
+
+func (m *msg91SMS) callback(r *http.Request) ([]smsStatusUpdate, error) {
+	if !validCallbackToken(r, m.callbackToken) {
+		return nil, errSMSCallbackUnauthorized
+	}
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+	var reports []struct {
+		RequestID string `json:"requestId"`
+		Report    []struct {
+			Status string `json:"status"`
+			Desc   string `json:"desc"`
+		} `json:"report"`
+	}
+	if err := json.Unmarshal([]byte(r.PostForm.Get("data")), &reports); err != nil {
+		return nil, err
+	}
+
+	var updates []smsStatusUpdate
+	for _, report := range reports {
+		for _, entry := range report.Report {
+			update := smsStatusUpdate{MessageID: report.RequestID}
+			switch entry.Status {
+			case "1":
+				update.Status = smsDelivered
+			case "5", "8":
+				update.Status = smsSent
+			default:
+				update.Status, update.Error = smsFailed, entry.Desc
+			}
+			updates = append(updates, update)
+		}
+	}
+	return updates, nil
+}
+
+// stateSMS sends through the CDAC Mobile Seva gateway used by state governments. It does not
+// push delivery reports itself; a relay can post them as JSON to SMS_CALLBACK_URL with
+// ?token=SMS_CALLBACK_TOKEN.
+type stateSMS struct {
+	endpoint      string
+	username      string
+	password      string
+	senderID      string
+	secureKey     string
+	callbackToken string
+	httpClient    *http.Client
+}
+
+func (s *stateSMS) name() string { return "state" }
+
+func (s *stateSMS) send(ctx context.Context, msg smsMessage) (string, error) {
+	passwordHash := sha1.Sum([]byte(s.password))
+	key := sha512.Sum512([]byte(s.username + s.senderID + msg.Body + s.secureKey))
+	form := url.Values{
+		"username":       {s.username},
+		"password":       {hex.EncodeToString(passwordHash[:])},
+		"senderid":       {s.senderID},
+		"content":        {msg.Body},
+		"smsservicetype": {"singlemsg"},
+		// Mobile Seva takes ten-digit Indian numbers
+		"mobileno":   {strings.TrimPrefix(msg.To, "+91")},
+		"key":        {hex.EncodeToString(key[:])},
+		"templateid": {msg.TemplateID},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", smsResponseError("State SMS gateway", resp)
+	}
+
+	// Success looks like "402,MsgID = 150620191153253hpgovt-hpssa"
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if err != nil {
+		return "", err
+	}
+	_, id, ok := strings.Cut(string(body), "MsgID")
+	if !ok {
+		return "", fmt.Errorf("state SMS gateway rejected the message: %s", strings.TrimSpace(string(body)))
+	}
+	return strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(id), "=")), nil
+}
+
+func (s *stateSMS) callback(r *http.Request) ([]smsStatusUpdate, error) {
+	if !validCallbackToken(r, s.callbackToken) {
+		return nil, errSMSCallbackUnauthorized
+	}
+	var report struct {
+		MessageID string `json:"message_id"`
+		Status    string `json:"status"`
+		Error     string `json:"error"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<16)).Decode(&report); err != nil {
+		return nil, err
+	}
+	if _, ok := smsStatusRank[report.Status]; !ok || report.MessageID == "" {
+		return nil, fmt.Errorf("expected message_id and a status of %s, %s, %s or %s", smsQueued, smsSent, smsDelivered, smsFailed)
+	}
+	return []smsStatusUpdate{{MessageID: report.MessageID, Status: report.Status, Error: report.Error}}, nil
+}
@@ -0,0 +1,90 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestTwilioSMSDeliveryReport(t *testing.T) {
+	var sent url.Values
+	twilio := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, _ := r.BasicAuth(); user != "AC123" || pass != "secret" || r.URL.Path != "/2010-04-01/Accounts/AC123/Messages.json" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		r.ParseForm()
+		sent = r.PostForm
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"sid":"SM1"}`))
+	}))
+	defer twilio.Close()
+
+	provider := &twilioSMS{endpoint: twilio.URL, accountSID: "AC123", authToken: "secret", from: "+15005550006", callbackURL: "https://sih.example.gov.in/callbacks/sms", httpClient: http.DefaultClient}
+	templates, err := loadSMSTemplates("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	smsGateway = &smsSender{provider: provider, templates: templates, countryCode: "+91", store: newMemorySMSStore(), ttl: time.Hour}
+	defer func() { smsGateway = nil }()
+
+	record, err := smsGateway.send(context.Background(), "missing_person", "098765 43210", "inc_1", smsData{RecipientName: "Asha", DigitalID: "did:sih:1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sent.Get("To") != "+919876543210" || !strings.Contains(sent.Get("Body"), "Dear Asha") || sent.Get("StatusCallback") != provider.callbackURL {
+		t.Fatalf("unexpected message %v", sent)
+	}
+	if record.MessageID != "SM1" || record.To != "*********3210" || record.Status != smsQueued {
+		t.Errorf("unexpected record %+v", record)
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/callbacks/sms", smsCallback)
+	report := func(status, signature string) int {
+		form := url.Values{"MessageSid": {"SM1"}, "MessageStatus": {status}}
+		req := httptest.NewRequest(http.MethodPost, "/callbacks/sms", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if signature == "" {
+			signature = twilioSignature(provider.authToken, provider.callbackURL, form)
+		}
+		req.Header.Set("X-Twilio-Signature", signature)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := report("delivered", "forged"); code != http.StatusForbidden {
+		t.Errorf("expected a forged report to be rejected, got %d", code)
+	}
+	if code := report("delivered", ""); code != http.StatusNoContent {
+		t.Fatalf("expected the signed report to be accepted, got %d", code)
+	}
+	report("sent", "")
+	records, _ := smsGateway.store.byReference(context.Background(), "inc_1")
+	if len(records) != 1 || records[0].Status != smsDelivered {
+		t.Errorf("expected the message to stay delivered after a late report, got %+v", records)
+	}
+}
+
+func TestNormalizeMobile(t *testing.T) {
+	for input, want := range map[string]string{
+		"+91 98765-43210":   "+919876543210",
+		"09876543210":       "+919876543210",
+		"0044 20 7946 0958": "+442079460958",
+	} {
+		if got, err := normalizeMobile(input, "+91"); err != nil || got != want {
+			t.Errorf("normalizeMobile(%q) = %q, %v; want %q", input, got, err, want)
+		}
+	}
+	if _, err := normalizeMobile("call 100", "+91"); err == nil {
+		t.Error("expected letters to be rejected")
+	}
+}
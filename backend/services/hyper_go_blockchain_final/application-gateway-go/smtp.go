@@ -0,0 +1,164 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+type emailMessage struct {
+	to             string
+	subject        string
+	body           string
+	unsubscribeURL string
+	attachments    []emailAttachment
+}
+
+// emailAttachment is a file sent with a message, such as a scheduled report
+type emailAttachment struct {
+	filename    string
+	contentType string
+	data        []byte
+}
+
+type mailSender interface {
+	send(ctx context.Context, message emailMessage) error
+}
+
+// smtpMailer delivers plain-text mail, as multipart/mixed when it has attachments. tlsMode is
+// "starttls" (port 587), "implicit" (port 465) or "none" for a local relay; credentials are only
+// sent over TLS.
+type smtpMailer struct {
+	addr     string
+	host     string
+	username string
+	password string
+	from     string
+	tlsMode  string
+	timeout  time.Duration
+}
+
+func (m *smtpMailer) send(ctx context.Context, message emailMessage) error {
+	dialer := &net.Dialer{Timeout: m.timeout}
+	var conn net.Conn
+	var err error
+	if m.tlsMode == "implicit" {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: m.host}}).DialContext(ctx, "tcp", m.addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", m.addr)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(m.timeout))
+
+	c, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if m.tlsMode == "starttls" {
+		if err := c.StartTLS(&tls.Config{ServerName: m.host}); err != nil {
+			return fmt.Errorf("STARTTLS failed: %w", err)
+		}
+	}
+	if m.username != "" {
+		if err := c.Auth(smtp.PlainAuth("", m.username, m.password, m.host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(m.from); err != nil {
+		return err
+	}
+	if err := c.Rcpt(message.to); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(m.format(message)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// format builds the RFC 5322 message, quoted-printable encoded so any UTF-8 survives 7-bit relays
+func (m *smtpMailer) format(message emailMessage) []byte {
+	id := make([]byte, 12)
+	rand.Read(id)
+	domain := m.host
+	if _, at, ok := strings.Cut(m.from, "@"); ok {
+		domain = at
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", m.from)
+	fmt.Fprintf(&buf, "To: %s\r\n", message.to)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", message.subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "Message-ID: <%s@%s>\r\n", hex.EncodeToString(id), domain)
+	if message.unsubscribeURL != "" {
+		fmt.Fprintf(&buf, "List-Unsubscribe: <%s>\r\n", message.unsubscribeURL)
+	}
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	if len(message.attachments) == 0 {
+		buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+		buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+		writeQuotedPrintable(&buf, message.body)
+		return buf.Bytes()
+	}
+
+	parts := multipart.NewWriter(&buf)
+	fmt.Fprintf(&buf, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", parts.Boundary())
+	text, _ := parts.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=utf-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	writeQuotedPrintable(text, message.body)
+	for _, attachment := range message.attachments {
+		part, _ := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {attachment.contentType},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": attachment.filename})},
+		})
+		// RFC 2045 limits encoded lines to 76 characters
+		encoded := base64.StdEncoding.EncodeToString(attachment.data)
+		for len(encoded) > 76 {
+			fmt.Fprintf(part, "%s\r\n", encoded[:76])
+			encoded = encoded[76:]
+		}
+		fmt.Fprintf(part, "%s\r\n", encoded)
+	}
+	parts.Close()
+	return buf.Bytes()
+}
+
+func writeQuotedPrintable(w io.Writer, body string) {
+	qp := quotedprintable.NewWriter(w)
+	qp.Write([]byte(strings.ReplaceAll(body, "\n", "\r\n")))
+	qp.Close()
+}
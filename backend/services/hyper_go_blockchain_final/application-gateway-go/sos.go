@@ -0,0 +1,418 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxSOSMessageLength = 500
+
+var sosSources = []string{"app", "sms", "kiosk", "wearable", "geofence", "chat"}
+
+// SOSRequest is an SOS raised by or on behalf of a tourist
+type SOSRequest struct {
+	// AlertID lets clients that queue alerts offline choose the ID; one is generated otherwise
+	AlertID   string   `json:"alertID"`
+	DigitalID string   `json:"digitalID" binding:"required"`
+	Latitude  *float64 `json:"latitude" binding:"required"`
+	Longitude *float64 `json:"longitude" binding:"required"`
+	Accuracy  float64  `json:"accuracy"`
+	Message   string   `json:"message"`
+	Source    string   `json:"source"`
+	// Confirmed skips the confirmation window, for alerts whose countdown already ran on the device
+	Confirmed bool `json:"confirmed"`
+}
+
+func (r SOSRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	if r.AlertID != "" {
+		v.identifier("alertID", r.AlertID)
+	}
+	v.digitalID("digitalID", r.DigitalID)
+	if r.Latitude != nil && (*r.Latitude < -90 || *r.Latitude > 90) {
+		v.add("latitude", "must be between -90 and 90")
+	}
+	if r.Longitude != nil && (*r.Longitude < -180 || *r.Longitude > 180) {
+		v.add("longitude", "must be between -180 and 180")
+	}
+	if r.Accuracy < 0 {
+		v.add("accuracy", "must not be negative")
+	}
+	if len(r.Message) > maxSOSMessageLength {
+		v.add("message", "must be at most %d characters", maxSOSMessageLength)
+	}
+	if r.Source != "" {
+		v.oneOf("source", r.Source, sosSources)
+	}
+	return v.errors
+}
+
+// PoliceUnit is a police station, patrol unit or ambulance that alerts can be routed to
+type PoliceUnit struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Kind is police or medical; units without one are police
+	Kind      string  `json:"kind,omitempty"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Phone     string  `json:"phone,omitempty"`
+	// Zone groups units for push subscriptions; a unit without one is its own zone
+	Zone string `json:"zone,omitempty"`
+	// District groups units and the incidents nearest them on the dashboard
+	District string `json:"district,omitempty"`
+	// WebhookURL receives alerts directly, for units with a dispatch console
+	WebhookURL string `json:"webhook_url,omitempty"`
+	// Shifts and Capabilities are the unit's defaults until its roster is set through the API
+	Shifts       []UnitShift `json:"shifts,omitempty"`
+	Capabilities []string    `json:"capabilities,omitempty"`
+}
+
+func (u *PoliceUnit) zone() string {
+	if u.Zone != "" {
+		return u.Zone
+	}
+	return u.ID
+}
+
+func (u *PoliceUnit) kind() string {
+	if u.Kind != "" {
+		return u.Kind
+	}
+	return unitPolice
+}
+
+func policeUnitByID(id string) *PoliceUnit {
+	for i := range policeUnits {
+		if id != "" && policeUnits[i].ID == id {
+			return &policeUnits[i]
+		}
+	}
+	return nil
+}
+
+// EmergencyContact is someone a tourist registered to be told when they raise an SOS
+type EmergencyContact struct {
+	Name     string `json:"name"`
+	Relation string `json:"relation,omitempty"`
+	Phone    string `json:"phone,omitempty"`
+	Email    string `json:"email,omitempty"`
+}
+
+// AssignedUnit is the police unit nearest to an SOS
+type AssignedUnit struct {
+	ID         string  `json:"id"`
+	Name       string  `json:"name"`
+	Phone      string  `json:"phone,omitempty"`
+	DistanceKm float64 `json:"distance_km"`
+}
+
+// SOSDocument is an SOS alert as the chaincode stores it
+type SOSDocument struct {
+	DocType      string `json:"doc_type"`
+	AlertID      string `json:"alert_id"`
+	DigitalID    string `json:"digital_id"`
+	LocationHash string `json:"location_hash"`
+	PoliceUnit   string `json:"police_unit,omitempty"`
+	Geohash      string `json:"geohash,omitempty"`
+	RaisedAt     string `json:"raised_at"`
+	OwnerOrg     string `json:"owner_org,omitempty"`
+	TxID         string `json:"tx_id"`
+}
+
+// SOSResult is a recorded SOS and the state of its notifications
+type SOSResult struct {
+	AlertID      string        `json:"alert_id"`
+	DigitalID    string        `json:"digital_id"`
+	LocationHash string        `json:"location_hash"`
+	PoliceUnit   *AssignedUnit `json:"police_unit,omitempty"`
+	Contacts     int           `json:"emergency_contacts"`
+	Dispatch     DispatchState `json:"dispatch"`
+	// NotificationID is the escalation case notifying the contacts, when a chain handles SOS alerts
+	NotificationID string `json:"notification_id,omitempty"`
+
+	Transaction *TransactionResult `json:"-"`
+}
+
+// contactDirectory resolves the emergency contacts a tourist registered
+type contactDirectory interface {
+	contacts(ctx context.Context, digitalID string) ([]EmergencyContact, error)
+}
+
+var (
+	policeUnits       []PoliceUnit
+	emergencyContacts contactDirectory
+	sosNotifier       *notifier
+)
+
+// initSOS loads the police unit directory and configures contact lookup and notification delivery.
+// SOS alerts are always recorded; without this configuration nobody is notified.
+func initSOS() {
+	if path := getEnv("POLICE_UNITS_FILE", ""); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			panic(fmt.Errorf("failed to read police units: %w", err))
+		}
+		if err := json.Unmarshal(data, &policeUnits); err != nil {
+			panic(fmt.Errorf("failed to parse police units: %w", err))
+		}
+		for _, unit := range policeUnits {
+			if !slices.Contains(unitKinds, unit.kind()) {
+				panic(fmt.Errorf("police unit %s has unknown kind %q", unit.ID, unit.Kind))
+			}
+			if unit.District != "" && len(validateDocumentID("district", unit.District)) > 0 {
+				panic(fmt.Errorf("police unit %s has district %q, which is not an identifier", unit.ID, unit.District))
+			}
+		}
+	}
+
+	timeout := getEnvDuration("SOS_NOTIFY_TIMEOUT", 5*time.Second)
+	httpClient := &http.Client{Timeout: timeout}
+	switch {
+	case getEnv("EMERGENCY_CONTACTS_URL", "") != "":
+		emergencyContacts = httpContactDirectory{urlTemplate: getEnv("EMERGENCY_CONTACTS_URL", ""), client: httpClient}
+	case getEnv("EMERGENCY_CONTACTS_FILE", "") != "":
+		data, err := os.ReadFile(getEnv("EMERGENCY_CONTACTS_FILE", ""))
+		if err != nil {
+			panic(fmt.Errorf("failed to read emergency contacts: %w", err))
+		}
+		var directory fileContactDirectory
+		if err := json.Unmarshal(data, &directory); err != nil {
+			panic(fmt.Errorf("failed to parse emergency contacts: %w", err))
+		}
+		emergencyContacts = directory
+	}
+
+	sosNotifier = &notifier{
+		gatewayURL: getEnv("NOTIFY_WEBHOOK_URL", ""),
+		secret:     []byte(getEnv("NOTIFY_WEBHOOK_SECRET", "")),
+		client:     httpClient,
+		timeout:    timeout,
+		wait:       getEnvDuration("SOS_DISPATCH_WAIT", 3*time.Second),
+	}
+	log.Printf("🆘 SOS routing to %d police units, notifications via %s", len(policeUnits), getEnv("NOTIFY_WEBHOOK_URL", "unit webhooks only"))
+}
+
+// RaiseSOS records the alert on the ledger, then notifies the nearest police unit and the tourist's
+// emergency contacts. With dispatch enabled the unit must be available and on shift, and it is
+// recorded as the alert's first assignment. Notifications that have not finished within SOS_DISPATCH_WAIT are reported
+// as pending and carry on in the background.
+func (ledgerService) RaiseSOS(ctx context.Context, req SOSRequest) (*SOSResult, error) {
+	if errs := req.Validate(); len(errs) > 0 {
+		return nil, errs
+	}
+
+	now := time.Now().UTC()
+	alertID := req.AlertID
+	if alertID == "" {
+		alertID = newSOSAlertID(now)
+	}
+	lat, lng := *req.Latitude, *req.Longitude
+
+	result := &SOSResult{AlertID: alertID, DigitalID: req.DigitalID, LocationHash: sosLocationHash(alertID, lat, lng)}
+	unit, distance := nearestPoliceUnit(policeUnits, lat, lng, float64(getEnvInt("SOS_MAX_UNIT_DISTANCE_KM", 50)))
+	if dispatcher != nil {
+		var err error
+		if unit, distance, err = dispatcher.nearestAvailable(ctx, unitQuery{kind: unitPolice, lat: lat, lng: lng}); err != nil {
+			return nil, err
+		}
+	}
+	unitID := ""
+	if unit != nil {
+		unitID = unit.ID
+		result.PoliceUnit = &AssignedUnit{ID: unit.ID, Name: unit.Name, Phone: unit.Phone, DistanceKm: math.Round(distance*100) / 100}
+	}
+
+	var err error
+	result.Transaction, err = submitTransaction(ctx, "RaiseSOSWithGeohash", alertID, req.DigitalID, result.LocationHash, unitID,
+		encodeGeohash(lat, lng, ledgerGeohashPrecision))
+	if err != nil {
+		return nil, err
+	}
+
+	var contacts []EmergencyContact
+	if emergencyContacts != nil {
+		if contacts, err = emergencyContacts.contacts(ctx, req.DigitalID); err != nil {
+			logWithContext(ctx, "Failed to resolve emergency contacts for SOS %s: %v", alertID, err)
+		}
+	}
+	result.Contacts = len(contacts)
+
+	alert := SOSNotification{
+		AlertID:   alertID,
+		DigitalID: req.DigitalID,
+		Latitude:  lat,
+		Longitude: lng,
+		Accuracy:  req.Accuracy,
+		Message:   req.Message,
+		Source:    req.Source,
+		RaisedAt:  now.Format(time.RFC3339),
+		MapURL:    fmt.Sprintf("https://maps.google.com/?q=%.6f,%.6f", lat, lng),
+		TxID:      result.Transaction.TxID,
+	}
+	if result.PoliceUnit != nil {
+		alert.PoliceUnit = result.PoliceUnit.Name
+		if dispatcher != nil {
+			alert.AssignmentID = assignmentID(alertID, unitPolice, 1)
+			go dispatcher.openSOS(context.WithoutCancel(ctx), alert, unit, distance)
+		}
+	}
+	if erss != nil {
+		erss.queueSOS(ctx, alert)
+	}
+	if orchestrator.handles("sos", "", "critical") {
+		result.NotificationID = orchestrator.start(ctx, EscalationCase{
+			Event:     "sos",
+			SubjectID: alertID,
+			DigitalID: req.DigitalID,
+			Severity:  "critical",
+			Title:     "SOS raised",
+			Message:   "Tourist " + req.DigitalID + " raised an SOS",
+			MapURL:    alert.MapURL,
+		})
+		contacts = nil
+	}
+	result.Dispatch = sosNotifier.fanOut(ctx, alert, unit, contacts)
+	return result, nil
+}
+
+func newSOSAlertID(now time.Time) string {
+	suffix := make([]byte, 3)
+	rand.Read(suffix)
+	return fmt.Sprintf("SOS-%s-%s", now.UTC().Format("20060102T150405Z"), hex.EncodeToString(suffix))
+}
+
+func (s ledgerService) GetSOS(ctx context.Context, id string) (SOSDocument, error) {
+	result, err := s.readDocument(ctx, "ReadSOS", id)
+	if err != nil {
+		return SOSDocument{}, err
+	}
+	alert, err := decodeDocument[SOSDocument](result, "SOS")
+	if err == nil && !tenantFromContext(ctx).owns(alert.OwnerOrg) {
+		return SOSDocument{}, fmt.Errorf("the SOS %s does not exist", id)
+	}
+	return alert, err
+}
+
+// sosLocationHash commits to the reported position without putting it on the ledger. The alert ID
+// salts the hash so positions cannot be recovered by hashing a grid of coordinates.
+func sosLocationHash(alertID string, lat, lng float64) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%.6f|%.6f", alertID, lat, lng)))
+	return hex.EncodeToString(sum[:])
+}
+
+// nearestPoliceUnit returns the closest police unit within maxKm of the position, or nil
+func nearestPoliceUnit(units []PoliceUnit, lat, lng, maxKm float64) (*PoliceUnit, float64) {
+	return nearestUnit(units, lat, lng, maxKm, func(u *PoliceUnit) bool { return u.kind() == unitPolice })
+}
+
+// nearestUnit returns the closest unit within maxKm that the filter accepts, or nil
+func nearestUnit(units []PoliceUnit, lat, lng, maxKm float64, accept func(*PoliceUnit) bool) (*PoliceUnit, float64) {
+	var nearest *PoliceUnit
+	best := maxKm
+	for i := range units {
+		if !accept(&units[i]) {
+			continue
+		}
+		if d := haversineKm(lat, lng, units[i].Latitude, units[i].Longitude); d <= best {
+			nearest, best = &units[i], d
+		}
+	}
+	return nearest, best
+}
+
+// haversineKm is the great-circle distance between two positions
+func haversineKm(lat1, lng1, lat2, lng2 float64) float64 {
+	const earthRadiusKm = 6371.0
+	rad := math.Pi / 180
+	dLat, dLng := (lat2-lat1)*rad, (lng2-lng1)*rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(a))
+}
+
+// fileContactDirectory maps digital IDs to contacts, loaded from EMERGENCY_CONTACTS_FILE
+type fileContactDirectory map[string][]EmergencyContact
+
+func (d fileContactDirectory) contacts(_ context.Context, digitalID string) ([]EmergencyContact, error) {
+	return d[digitalID], nil
+}
+
+// httpContactDirectory asks the tourist registry for contacts. urlTemplate contains {digitalID},
+// and the registry answers with a JSON array of contacts or 404 when there are none.
+type httpContactDirectory struct {
+	urlTemplate string
+	client      *http.Client
+}
+
+func (d httpContactDirectory) contacts(ctx context.Context, digitalID string) ([]EmergencyContact, error) {
+	target := strings.ReplaceAll(d.urlTemplate, "{digitalID}", url.PathEscape(digitalID))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		var contacts []EmergencyContact
+		if err := json.NewDecoder(resp.Body).Decode(&contacts); err != nil {
+			return nil, fmt.Errorf("invalid contacts response: %w", err)
+		}
+		return contacts, nil
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, errors.New("contact registry responded with " + resp.Status)
+	}
+}
+
+// raiseSOS raises an SOS at once, or holds one triggered from the app for its confirmation window
+func raiseSOS(c *gin.Context) {
+	var req SOSRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+	if sosConfirm.holds(req) {
+		pending, err := sosConfirm.trigger(c.Request.Context(), req, time.Now())
+		if err != nil {
+			respondServiceError(c, "Failed to hold SOS", err)
+			return
+		}
+		setAuditTarget(c, pending.AlertID)
+		respondData(c, http.StatusAccepted, pending)
+		return
+	}
+
+	result, err := ledger.RaiseSOS(c.Request.Context(), req)
+	if err != nil {
+		respondServiceError(c, "Failed to raise SOS", err)
+		return
+	}
+	setAuditTarget(c, result.AlertID)
+
+	respondCommitted(c, http.StatusCreated, result, result.Transaction)
+}
@@ -0,0 +1,69 @@
+package gateway
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNearestPoliceUnit(t *testing.T) {
+	units := []PoliceUnit{
+		{ID: "shillong", Latitude: 25.5788, Longitude: 91.8933},
+		{ID: "guwahati", Latitude: 26.1445, Longitude: 91.7362},
+	}
+	unit, distance := nearestPoliceUnit(units, 26.10, 91.70, 50)
+	if unit == nil || unit.ID != "guwahati" || distance > 10 {
+		t.Fatalf("expected Guwahati within 10km, got %+v at %.1fkm", unit, distance)
+	}
+	if unit, _ := nearestPoliceUnit(units, 28.61, 77.21, 50); unit != nil {
+		t.Errorf("expected no unit within 50km of Delhi, got %s", unit.ID)
+	}
+}
+
+func TestSOSFanOut(t *testing.T) {
+	secret := []byte("shared")
+	var gatewayCalls atomic.Int32
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(body)
+		if r.Header.Get(signatureHeader) != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		gatewayCalls.Add(1)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer gateway.Close()
+	release := make(chan struct{})
+	slowUnit := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slowUnit.Close()
+	defer close(release)
+
+	n := &notifier{gatewayURL: gateway.URL, secret: secret, client: http.DefaultClient, timeout: time.Second, wait: 200 * time.Millisecond}
+	alert := SOSNotification{AlertID: "SOS-1", DigitalID: "did:sih:1"}
+	contacts := []EmergencyContact{{Name: "Asha", Phone: "+911234567890", Email: "asha@example.com"}}
+
+	state := n.fanOut(context.Background(), alert, &PoliceUnit{Name: "Control room", Phone: "100"}, contacts)
+	if state.State != notifyDelivered || len(state.Notifications) != 3 || gatewayCalls.Load() != 3 {
+		t.Fatalf("expected three signed deliveries, got %+v after %d calls", state, gatewayCalls.Load())
+	}
+
+	state = n.fanOut(context.Background(), alert, &PoliceUnit{Name: "Patrol", WebhookURL: slowUnit.URL}, contacts)
+	if state.State != "partial" || state.Notifications[0].Status != notifyPending {
+		t.Errorf("expected the slow unit webhook to be reported as pending, got %+v", state)
+	}
+
+	if state := n.fanOut(context.Background(), alert, nil, nil); state.State != "none" {
+		t.Errorf("expected nobody to notify, got %s", state.State)
+	}
+}
@@ -0,0 +1,479 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	errCodeSOSNotPending = "SOS_NOT_PENDING"
+
+	sosPendingPrefix   = "sih:sos:pending:"
+	sosPendingDueKey   = "sih:sos:pending"
+	sosLeasePrefix     = "sih:sos:lease:"
+	sosTriggerPrefix   = "TRIGGER-"
+	sosConfirmActor    = "sos-confirmation"
+	sosTriggerPending  = "pending"
+	sosTriggerCancel   = "cancelled"
+	sosTriggerEscalate = "escalated"
+	maxSOSCancelReason = 200
+	// sosPendingRetention keeps a held trigger's record past its window, in case escalation is retried
+	sosPendingRetention = time.Hour
+	// sosPendingLease is how long a claimed trigger stays with the instance raising or recording it.
+	// A trigger whose instance dies before finishing falls due again once the lease runs out.
+	sosPendingLease = time.Minute
+)
+
+// PendingSOS is an SOS triggered from the app and held for its confirmation window. Unless the
+// tourist cancels or confirms it first, it is raised at EscalatesAt.
+type PendingSOS struct {
+	AlertID     string `json:"alert_id"`
+	DigitalID   string `json:"digital_id"`
+	Status      string `json:"status"`
+	TriggeredAt string `json:"triggered_at"`
+	EscalatesAt string `json:"escalates_at"`
+}
+
+// pendingSOS is a held trigger with the request that raises it
+type pendingSOS struct {
+	Request     SOSRequest `json:"request"`
+	TriggeredAt string     `json:"triggered_at"`
+	EscalatesAt string     `json:"escalates_at"`
+}
+
+func (p pendingSOS) view() PendingSOS {
+	return PendingSOS{AlertID: p.Request.AlertID, DigitalID: p.Request.DigitalID, Status: sosTriggerPending, TriggeredAt: p.TriggeredAt, EscalatesAt: p.EscalatesAt}
+}
+
+// SOSDecisionRequest confirms or cancels a held SOS on behalf of the tourist who triggered it
+type SOSDecisionRequest struct {
+	DigitalID string `json:"digitalID" binding:"required"`
+	Reason    string `json:"reason"`
+}
+
+func (r SOSDecisionRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.digitalID("digitalID", r.DigitalID)
+	if len(r.Reason) > maxSOSCancelReason {
+		v.add("reason", "must be at most %d characters", maxSOSCancelReason)
+	}
+	return v.errors
+}
+
+// SOSTrigger is the end of a confirmation window as the chaincode stores it
+type SOSTrigger struct {
+	TriggerID   string `json:"trigger_id"`
+	AlertID     string `json:"alert_id"`
+	DigitalID   string `json:"digital_id"`
+	Outcome     string `json:"outcome"`
+	TriggeredAt string `json:"triggered_at"`
+	DecidedAt   string `json:"decided_at"`
+	Reason      string `json:"reason,omitempty"`
+	RecordedBy  string `json:"recorded_by"`
+	RecordedAt  string `json:"recorded_at"`
+	OwnerOrg    string `json:"owner_org,omitempty"`
+	TxID        string `json:"tx_id"`
+}
+
+// pendingSOSStore holds triggers until their window closes, so a trigger survives a restart and may
+// be confirmed or cancelled through any gateway instance. A trigger is claimed before it is raised or
+// recorded and stays held until that succeeds.
+type pendingSOSStore interface {
+	// hold saves a trigger unless one with its alert ID is already held, which it returns instead
+	hold(ctx context.Context, p pendingSOS) (*pendingSOS, error)
+	get(ctx context.Context, alertID string) (*pendingSOS, error)
+	// claim leases a held trigger to the caller, or returns nil when it is gone or another caller holds it
+	claim(ctx context.Context, alertID string, now time.Time) (*pendingSOS, error)
+	// complete removes a claimed trigger once it has been raised or recorded
+	complete(ctx context.Context, alertID string) error
+	// release returns a claimed trigger to be held until its EscalatesAt
+	release(ctx context.Context, p pendingSOS) error
+	// due returns the alert IDs whose window closed before now
+	due(ctx context.Context, now time.Time) ([]string, error)
+}
+
+// sosConfirmation holds SOS alerts triggered from the listed sources for a countdown in which the
+// tourist may cancel an accidental trigger. Alerts neither cancelled nor confirmed are raised when
+// it ends. Cancellations and escalations are recorded on the ledger.
+type sosConfirmation struct {
+	store   pendingSOSStore
+	window  time.Duration
+	sources []string
+}
+
+var sosConfirm *sosConfirmation
+
+// initSOSConfirmation runs after initDocumentCache. Held alerts must outlive the instance holding them,
+// so the window needs Redis and defaults to 0, raising every SOS at once, without it.
+func initSOSConfirmation() {
+	defaultWindow := time.Duration(0)
+	if documentCache != nil {
+		defaultWindow = 15 * time.Second
+	}
+	window := getEnvDuration("SOS_CONFIRM_WINDOW", defaultWindow)
+	if window <= 0 {
+		log.Println("🆘 SOS_CONFIRM_WINDOW is 0, SOS alerts are raised without a confirmation window")
+		return
+	}
+	if documentCache == nil {
+		panic(errors.New("SOS_CONFIRM_WINDOW requires Redis; set REDIS_URL"))
+	}
+	sources := splitList(getEnv("SOS_CONFIRM_SOURCES", "app"))
+	for _, source := range sources {
+		if !slices.Contains(sosSources, source) {
+			panic(fmt.Errorf("SOS_CONFIRM_SOURCES must be a list of %s", strings.Join(sosSources, ", ")))
+		}
+	}
+	store := redisPendingSOSStore{redis: documentCache, ttl: window + sosPendingRetention, lease: sosPendingLease}
+	sosConfirm = &sosConfirmation{store: store, window: window, sources: sources}
+	log.Printf("🆘 Holding SOS alerts from %s for %s before raising them", strings.Join(sources, ", "), window)
+}
+
+// holds reports whether an SOS waits for its window. Alerts without a source come from the app, and
+// confirmed alerts already ran their countdown on the device.
+func (s *sosConfirmation) holds(req SOSRequest) bool {
+	if s == nil || req.Confirmed {
+		return false
+	}
+	source := req.Source
+	if source == "" {
+		source = "app"
+	}
+	return slices.Contains(s.sources, source)
+}
+
+// trigger holds an SOS for the window. Retrying a trigger with the same alert ID returns the one held.
+func (s *sosConfirmation) trigger(ctx context.Context, req SOSRequest, now time.Time) (PendingSOS, error) {
+	if req.AlertID == "" {
+		req.AlertID = newSOSAlertID(now)
+	}
+	held, err := s.store.hold(ctx, pendingSOS{
+		Request:     req,
+		TriggeredAt: now.UTC().Format(time.RFC3339),
+		EscalatesAt: now.Add(s.window).UTC().Format(time.RFC3339Nano),
+	})
+	if err != nil {
+		return PendingSOS{}, err
+	}
+	return held.view(), nil
+}
+
+// decide claims a held trigger for the tourist who raised it. Triggers of other tourists read as
+// missing, so alert IDs cannot be probed.
+func (s *sosConfirmation) decide(ctx context.Context, alertID, digitalID string, now time.Time) (*pendingSOS, bool, error) {
+	held, err := s.store.get(ctx, alertID)
+	if err != nil || held == nil {
+		return nil, false, err
+	}
+	if held.Request.DigitalID != digitalID {
+		return nil, false, nil
+	}
+	claimed, err := s.store.claim(ctx, alertID, now)
+	return claimed, true, err
+}
+
+// settle completes a claimed trigger once it was raised or recorded, and otherwise releases it to
+// be held until escalatesAt, so the alert is never dropped
+func (s *sosConfirmation) settle(ctx context.Context, p pendingSOS, escalatesAt time.Time, failed bool) {
+	alertID := p.Request.AlertID
+	if !failed {
+		if err := s.store.complete(ctx, alertID); err != nil {
+			log.Printf("🆘 Failed to complete held SOS %s: %v", alertID, err)
+		}
+		return
+	}
+	p.EscalatesAt = escalatesAt.UTC().Format(time.RFC3339Nano)
+	if err := s.store.release(ctx, p); err != nil {
+		log.Printf("🆘 Failed to hold SOS %s again, it falls due when its lease ends: %v", alertID, err)
+	}
+}
+
+// record writes the end of a confirmation window to the ledger
+func (s *sosConfirmation) record(ctx context.Context, p pendingSOS, outcome, reason, actor string, decided time.Time) (*TransactionResult, error) {
+	alertID := p.Request.AlertID
+	return submitTransaction(ctx, "RecordSOSTrigger", sosTriggerPrefix+alertID, alertID, p.Request.DigitalID, outcome,
+		p.TriggeredAt, decided.UTC().Format(time.RFC3339), reason, actor)
+}
+
+// run raises the triggers whose window closed. Each is claimed by exactly one gateway instance.
+func (s *sosConfirmation) run(ctx context.Context) {
+	ticker := time.NewTicker(min(s.window/4, time.Second))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.sweep(context.Background(), time.Now()); err != nil {
+				log.Printf("🆘 Failed to sweep held SOS alerts: %v", err)
+			}
+		}
+	}
+}
+
+func (s *sosConfirmation) sweep(ctx context.Context, now time.Time) error {
+	due, err := s.store.due(ctx, now)
+	if err != nil {
+		return err
+	}
+	for _, alertID := range due {
+		p, err := s.store.claim(ctx, alertID, now)
+		if err != nil {
+			return err
+		}
+		if p != nil {
+			s.escalate(ctx, *p, now)
+		}
+	}
+	return nil
+}
+
+// escalate raises an unconfirmed alert and records that nobody cancelled it. When raising fails,
+// the trigger is held for another window and retried.
+func (s *sosConfirmation) escalate(ctx context.Context, p pendingSOS, now time.Time) {
+	alertID := p.Request.AlertID
+	if _, err := ledger.RaiseSOS(ctx, p.Request); err != nil && translateFabricError(err).Code != errCodeAlreadyExists {
+		log.Printf("🆘 Failed to raise held SOS %s, retrying in %s: %v", alertID, s.window, err)
+		s.settle(ctx, p, now.Add(s.window), true)
+		return
+	}
+	s.settle(ctx, p, now, false)
+	if _, err := s.record(ctx, p, sosTriggerEscalate, "", sosConfirmActor, now); err != nil {
+		log.Printf("🆘 Failed to record escalation of SOS %s: %v", alertID, err)
+	}
+}
+
+func (s ledgerService) GetSOSTrigger(ctx context.Context, alertID string) (SOSTrigger, error) {
+	result, err := s.readDocument(ctx, "ReadSOSTrigger", sosTriggerPrefix+alertID)
+	if err != nil {
+		return SOSTrigger{}, err
+	}
+	trigger, err := decodeDocument[SOSTrigger](result, "SOS trigger")
+	if err == nil && !tenantFromContext(ctx).owns(trigger.OwnerOrg) {
+		return SOSTrigger{}, fmt.Errorf("the SOS trigger %s does not exist", sosTriggerPrefix+alertID)
+	}
+	return trigger, err
+}
+
+// redisPendingSOSStore keeps a key per held trigger and the windows' ends in a sorted set scored by
+// time. A trigger is claimed with a lease key set NX, so only one caller confirms, cancels or
+// escalates each, and its score moves to the lease's end, so a trigger whose claimant dies falls due
+// again rather than being lost.
+type redisPendingSOSStore struct {
+	redis *redisClient
+	ttl   time.Duration
+	lease time.Duration
+}
+
+func (s redisPendingSOSStore) hold(ctx context.Context, p pendingSOS) (*pendingSOS, error) {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	key := sosPendingPrefix + p.Request.AlertID
+	_, err = s.redis.Do(ctx, "SET", key, string(data), "NX", "PX", strconv.FormatInt(s.ttl.Milliseconds(), 10))
+	if errors.Is(err, errRedisNil) {
+		return s.get(ctx, p.Request.AlertID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &p, s.schedule(ctx, p)
+}
+
+func (s redisPendingSOSStore) schedule(ctx context.Context, p pendingSOS) error {
+	escalates, _ := time.Parse(time.RFC3339Nano, p.EscalatesAt)
+	_, err := s.redis.Do(ctx, "ZADD", sosPendingDueKey, strconv.FormatInt(escalates.UnixMilli(), 10), p.Request.AlertID)
+	return err
+}
+
+func (s redisPendingSOSStore) get(ctx context.Context, alertID string) (*pendingSOS, error) {
+	data, err := s.redis.Get(ctx, sosPendingPrefix+alertID)
+	if errors.Is(err, errRedisNil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var p pendingSOS
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (s redisPendingSOSStore) claim(ctx context.Context, alertID string, now time.Time) (*pendingSOS, error) {
+	lease := sosLeasePrefix + alertID
+	_, err := s.redis.Do(ctx, "SET", lease, "1", "NX", "PX", strconv.FormatInt(s.lease.Milliseconds(), 10))
+	if errors.Is(err, errRedisNil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	p, err := s.get(ctx, alertID)
+	if err != nil || p == nil {
+		return nil, errors.Join(err, s.redis.Del(ctx, lease))
+	}
+	_, err = s.redis.Do(ctx, "ZADD", sosPendingDueKey, "XX", strconv.FormatInt(now.Add(s.lease).UnixMilli(), 10), alertID)
+	return p, err
+}
+
+func (s redisPendingSOSStore) complete(ctx context.Context, alertID string) error {
+	if err := s.redis.Del(ctx, sosPendingPrefix+alertID); err != nil {
+		return err
+	}
+	if _, err := s.redis.Do(ctx, "ZREM", sosPendingDueKey, alertID); err != nil {
+		return err
+	}
+	return s.redis.Del(ctx, sosLeasePrefix+alertID)
+}
+
+func (s redisPendingSOSStore) release(ctx context.Context, p pendingSOS) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	if err := s.redis.Set(ctx, sosPendingPrefix+p.Request.AlertID, data, s.ttl); err != nil {
+		return err
+	}
+	if err := s.schedule(ctx, p); err != nil {
+		return err
+	}
+	return s.redis.Del(ctx, sosLeasePrefix+p.Request.AlertID)
+}
+
+func (s redisPendingSOSStore) due(ctx context.Context, now time.Time) ([]string, error) {
+	reply, err := s.redis.Do(ctx, "ZRANGEBYSCORE", sosPendingDueKey, "-inf", "("+strconv.FormatInt(now.UnixMilli(), 10), "LIMIT", "0", "100")
+	if err != nil {
+		return nil, err
+	}
+	members, _ := reply.([]interface{})
+	due := make([]string, 0, len(members))
+	for _, member := range members {
+		raw, _ := member.([]byte)
+		due = append(due, string(raw))
+	}
+	return due, nil
+}
+
+// heldSOS binds a decision on a held SOS and claims it, answering when there is nothing to decide.
+// A non-empty passkeyAction needs the tourist's passkey assertion before the SOS is claimed.
+func heldSOS(c *gin.Context, passkeyAction string) (*pendingSOS, *SOSDecisionRequest, bool) {
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return nil, nil, false
+	}
+	var req SOSDecisionRequest
+	if !bindRequest(c, &req) {
+		return nil, nil, false
+	}
+	setAuditTarget(c, id)
+	if passkeyAction != "" && !passkeyAsserted(c, req.DigitalID, passkeyAction) {
+		return nil, nil, false
+	}
+	if sosConfirm == nil {
+		respondError(c, http.StatusConflict, errCodeSOSNotPending, "SOS alerts are raised without a confirmation window")
+		return nil, nil, false
+	}
+	p, owned, err := sosConfirm.decide(c.Request.Context(), id, req.DigitalID, time.Now())
+	switch {
+	case err != nil:
+		respondServiceError(c, "Failed to read held SOS", err)
+	case !owned:
+		respondError(c, http.StatusNotFound, errCodeNotFound, "No held SOS with this ID for this tourist")
+	case p == nil:
+		respondError(c, http.StatusConflict, errCodeSOSNotPending, "The SOS is no longer awaiting confirmation")
+	default:
+		return p, &req, true
+	}
+	return nil, nil, false
+}
+
+// confirmSOS raises a held SOS before its window ends
+func confirmSOS(c *gin.Context) {
+	p, _, ok := heldSOS(c, "")
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	result, err := ledger.RaiseSOS(ctx, p.Request)
+	// Held again on failure, so it still escalates when its window ends
+	escalates, _ := time.Parse(time.RFC3339Nano, p.EscalatesAt)
+	sosConfirm.settle(context.WithoutCancel(ctx), *p, escalates, err != nil)
+	if err != nil {
+		respondServiceError(c, "Failed to raise SOS", err)
+		return
+	}
+	respondCommitted(c, http.StatusCreated, result, result.Transaction)
+}
+
+// cancelSOS withdraws a held SOS the tourist triggered by accident and records the cancellation
+func cancelSOS(c *gin.Context) {
+	p, req, ok := heldSOS(c, passkeyActionSOSCancel)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	now := time.Now().UTC()
+	result, err := sosConfirm.record(ctx, *p, sosTriggerCancel, req.Reason, req.DigitalID, now)
+	escalates, _ := time.Parse(time.RFC3339Nano, p.EscalatesAt)
+	sosConfirm.settle(context.WithoutCancel(ctx), *p, escalates, err != nil)
+	if err != nil {
+		respondServiceError(c, "Failed to cancel SOS", err)
+		return
+	}
+	respondCommitted(c, http.StatusOK, SOSTrigger{
+		TriggerID:   sosTriggerPrefix + p.Request.AlertID,
+		AlertID:     p.Request.AlertID,
+		DigitalID:   p.Request.DigitalID,
+		Outcome:     sosTriggerCancel,
+		TriggeredAt: p.TriggeredAt,
+		DecidedAt:   now.Format(time.RFC3339),
+		Reason:      req.Reason,
+		RecordedBy:  req.DigitalID,
+		RecordedAt:  now.Format(time.RFC3339),
+		TxID:        result.TxID,
+	}, result)
+}
+
+// getSOSTrigger reads how an SOS's confirmation window ended, or that it is still held
+func getSOSTrigger(c *gin.Context) {
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	if sosConfirm != nil {
+		held, err := sosConfirm.store.get(c.Request.Context(), id)
+		if err != nil {
+			respondServiceError(c, "Failed to read held SOS", err)
+			return
+		}
+		if held != nil {
+			respondData(c, http.StatusOK, held.view())
+			return
+		}
+	}
+	trigger, err := ledger.GetSOSTrigger(c.Request.Context(), id)
+	if err != nil {
+		respondServiceError(c, "Failed to read SOS trigger", err)
+		return
+	}
+	respondData(c, http.StatusOK, trigger)
+}
@@ -0,0 +1,153 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+)
+
+func TestSOSConfirmationWindow(t *testing.T) {
+	store := redisPendingSOSStore{redis: startTestRedis(t), ttl: time.Hour, lease: time.Minute}
+	sosConfirm = &sosConfirmation{store: store, window: 15 * time.Second, sources: []string{"app", "wearable"}}
+	defer func() { sosConfirm = nil }()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/sos", raiseSOS)
+	r.POST("/sos/:id/confirm", confirmSOS)
+	r.POST("/sos/:id/cancel", cancelSOS)
+	r.GET("/sos/:id/trigger", getSOSTrigger)
+	request := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// A press in the app is held rather than raised, and retrying the trigger keeps its window
+	body := `{"alertID": "SOS-APP-1", "digitalID": "did:sih:tourist_001", "latitude": 25.5788, "longitude": 91.8933}`
+	w := request(http.MethodPost, "/sos", body)
+	var envelope struct {
+		Data PendingSOS `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &envelope); err != nil || w.Code != http.StatusAccepted {
+		t.Fatalf("expected the SOS held, got %d %s", w.Code, w.Body)
+	}
+	held := envelope.Data
+	if held.Status != sosTriggerPending || held.AlertID != "SOS-APP-1" || held.EscalatesAt <= held.TriggeredAt {
+		t.Errorf("unexpected held SOS %+v", held)
+	}
+	if w = request(http.MethodPost, "/sos", body); !strings.Contains(w.Body.String(), held.EscalatesAt) {
+		t.Errorf("expected the retried trigger to keep its window, got %s", w.Body)
+	}
+	if w = request(http.MethodGet, "/sos/SOS-APP-1/trigger", ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"status":"pending"`) {
+		t.Errorf("expected the held SOS readable, got %d %s", w.Code, w.Body)
+	}
+
+	// Another tourist cannot decide it, and nothing is left to decide for unknown alerts
+	if w = request(http.MethodPost, "/sos/SOS-APP-1/cancel", `{"digitalID": "did:sih:tourist_002"}`); w.Code != http.StatusNotFound {
+		t.Errorf("expected another tourist's cancel refused, got %d", w.Code)
+	}
+	if w = request(http.MethodPost, "/sos/SOS-APP-9/confirm", `{"digitalID": "did:sih:tourist_001"}`); w.Code != http.StatusNotFound {
+		t.Errorf("expected an unknown SOS reported missing, got %d", w.Code)
+	}
+	if p, _ := sosConfirm.store.get(context.Background(), "SOS-APP-1"); p == nil {
+		t.Error("expected refused decisions to leave the SOS held")
+	}
+}
+
+func TestSOSConfirmationHolds(t *testing.T) {
+	s := &sosConfirmation{sources: []string{"app", "wearable"}}
+	cases := []struct {
+		req   SOSRequest
+		holds bool
+	}{
+		{SOSRequest{}, true},
+		{SOSRequest{Source: "wearable"}, true},
+		{SOSRequest{Source: "app", Confirmed: true}, false},
+		{SOSRequest{Source: "sms"}, false},
+		{SOSRequest{Source: "geofence"}, false},
+	}
+	for _, tc := range cases {
+		if s.holds(tc.req) != tc.holds {
+			t.Errorf("%+v: expected holds %v", tc.req, tc.holds)
+		}
+	}
+	var disabled *sosConfirmation
+	if disabled.holds(SOSRequest{Source: "app"}) {
+		t.Error("expected every SOS raised at once without a window")
+	}
+}
+
+func TestPendingSOSStore(t *testing.T) {
+	server := miniredis.RunT(t)
+	client, err := newRedisClient("redis://"+server.Addr(), redisStandalone, 2, time.Second, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	store := redisPendingSOSStore{redis: client, ttl: time.Hour, lease: time.Minute}
+	ctx, now := context.Background(), time.Now()
+	for i, id := range []string{"SOS-B", "SOS-A", "SOS-C"} {
+		store.hold(ctx, pendingSOS{Request: SOSRequest{AlertID: id}, EscalatesAt: now.Add(time.Duration(i-2) * time.Second).Format(time.RFC3339Nano)})
+	}
+	due, _ := store.due(ctx, now)
+	if len(due) != 2 || due[0] != "SOS-B" || due[1] != "SOS-A" {
+		t.Errorf("expected the two closed windows due by their end, got %v", due)
+	}
+
+	// A claimed trigger stays held, but nobody else may claim it while the lease runs
+	p, _ := store.claim(ctx, "SOS-A", now)
+	if p == nil || p.Request.AlertID != "SOS-A" {
+		t.Fatalf("expected SOS-A claimed, got %+v", p)
+	}
+	if again, _ := store.claim(ctx, "SOS-A", now); again != nil {
+		t.Error("expected a trigger claimed only once")
+	}
+	if due, _ = store.due(ctx, now); len(due) != 1 || due[0] != "SOS-B" {
+		t.Errorf("expected the claimed trigger out of the due set, got %v", due)
+	}
+	if held, _ := store.get(ctx, "SOS-A"); held == nil {
+		t.Error("expected the claimed trigger still held")
+	}
+
+	// A claimant that dies leaves the trigger to fall due again when its lease ends
+	server.FastForward(time.Minute)
+	later := now.Add(time.Minute + time.Second)
+	if due, _ = store.due(ctx, later); !slices.Contains(due, "SOS-A") {
+		t.Errorf("expected the abandoned trigger due again, got %v", due)
+	}
+	if p, _ = store.claim(ctx, "SOS-A", later); p == nil {
+		t.Fatal("expected the abandoned trigger claimed again")
+	}
+
+	// A failed raise releases the trigger for another window, and a raised one is gone
+	p.EscalatesAt = later.Add(15 * time.Second).Format(time.RFC3339Nano)
+	if err := store.release(ctx, *p); err != nil {
+		t.Fatal(err)
+	}
+	if due, _ = store.due(ctx, later); slices.Contains(due, "SOS-A") {
+		t.Errorf("expected the released trigger not due until its new window ends, got %v", due)
+	}
+	if p, _ = store.claim(ctx, "SOS-A", later); p == nil {
+		t.Fatal("expected the released trigger claimable")
+	}
+	if err := store.complete(ctx, "SOS-A"); err != nil {
+		t.Fatal(err)
+	}
+	if held, _ := store.get(ctx, "SOS-A"); held != nil {
+		t.Error("expected the completed trigger removed")
+	}
+	if p, _ = store.claim(ctx, "SOS-A", later); p != nil {
+		t.Error("expected nothing left to claim")
+	}
+}
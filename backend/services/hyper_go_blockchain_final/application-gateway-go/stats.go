@@ -0,0 +1,187 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DashboardStats is the operations dashboard summary returned by GET /api/v1/stats
+type DashboardStats struct {
+	GeneratedAt             string         `json:"generated_at"`
+	Source                  string         `json:"source"`
+	OpenIncidents           int            `json:"open_incidents"`
+	OpenIncidentsBySeverity map[string]int `json:"open_incidents_by_severity"`
+	SOSLast24h              int            `json:"sos_last_24h"`
+	DIDs                    DIDStats       `json:"dids"`
+	// AvgTimeToAcknowledge is in seconds, over incidents acknowledged within AcknowledgeWindow; it
+	// is null when there were none
+	AvgTimeToAcknowledge *float64      `json:"avg_time_to_acknowledge_seconds"`
+	AcknowledgeWindow    string        `json:"acknowledge_window"`
+	Evidence             EvidenceStats `json:"evidence"`
+}
+
+// DIDStats counts issued DIDs and those expiring within ExpiringWindow
+type DIDStats struct {
+	Issued         int    `json:"issued"`
+	IssuedLast24h  int    `json:"issued_last_24h"`
+	Expiring       int    `json:"expiring"`
+	ExpiringWindow string `json:"expiring_window"`
+}
+
+// EvidenceStats counts anchored evidence records
+type EvidenceStats struct {
+	Total   int `json:"total"`
+	Last24h int `json:"last_24h"`
+}
+
+// statsQuery fixes the instant and windows the statistics are computed over
+type statsQuery struct {
+	now               time.Time
+	expiringWindow    time.Duration
+	acknowledgeWindow time.Duration
+}
+
+// statsSource computes dashboard statistics
+type statsSource interface {
+	Name() string
+	DashboardStats(ctx context.Context, query statsQuery) (DashboardStats, error)
+}
+
+// ledgerStatsSource computes statistics with rich queries against the peers' state database
+type ledgerStatsSource struct{}
+
+func (ledgerStatsSource) Name() string {
+	return "ledger"
+}
+
+func (ledgerStatsSource) DashboardStats(ctx context.Context, query statsQuery) (DashboardStats, error) {
+	now := query.now.UTC()
+	result, err := evaluateTransaction(ctx, "GetDashboardStats",
+		now.Add(-24*time.Hour).Format(time.RFC3339),
+		now.Add(-query.acknowledgeWindow).Format(time.RFC3339),
+		now.Format(time.RFC3339),
+		now.Add(query.expiringWindow).Format(time.RFC3339))
+	if err != nil {
+		return DashboardStats{}, err
+	}
+
+	var counts struct {
+		OpenIncidentsBySeverity map[string]int `json:"open_incidents_by_severity"`
+		SOSAlerts               int            `json:"sos_alerts"`
+		DIDsIssued              int            `json:"dids_issued"`
+		DIDsIssuedRecently      int            `json:"dids_issued_recently"`
+		DIDsExpiring            int            `json:"dids_expiring"`
+		AcknowledgedIncidents   int            `json:"acknowledged_incidents"`
+		AcknowledgeSeconds      int64          `json:"acknowledge_seconds"`
+		EvidenceCount           int            `json:"evidence_count"`
+		EvidenceRecently        int            `json:"evidence_recently"`
+	}
+	if err := json.Unmarshal(result, &counts); err != nil {
+		return DashboardStats{}, &malformedDocumentError{kind: "statistics", err: err}
+	}
+
+	stats := DashboardStats{
+		OpenIncidentsBySeverity: counts.OpenIncidentsBySeverity,
+		SOSLast24h:              counts.SOSAlerts,
+		DIDs:                    DIDStats{Issued: counts.DIDsIssued, IssuedLast24h: counts.DIDsIssuedRecently, Expiring: counts.DIDsExpiring},
+		Evidence:                EvidenceStats{Total: counts.EvidenceCount, Last24h: counts.EvidenceRecently},
+	}
+	if counts.AcknowledgedIncidents > 0 {
+		avg := float64(counts.AcknowledgeSeconds) / float64(counts.AcknowledgedIncidents)
+		stats.AvgTimeToAcknowledge = &avg
+	}
+	return stats, nil
+}
+
+// statsCache serves dashboard statistics for ttl after computing them. Concurrent requests for
+// expired statistics wait on a single refresh rather than each scanning the ledger.
+type statsCache struct {
+	mu                sync.Mutex
+	source            statsSource
+	ttl               time.Duration
+	expiringWindow    time.Duration
+	acknowledgeWindow time.Duration
+	value             DashboardStats
+	expires           time.Time
+}
+
+var (
+	dashboardStats *statsCache
+	// targetStats holds a ledger-backed cache for each non-default target, created on first use
+	targetStats sync.Map
+)
+
+// initStats configures the statistics windows and cache lifetime
+func initStats() {
+	dashboardStats = &statsCache{
+		source:            ledgerStatsSource{},
+		ttl:               getEnvDuration("STATS_CACHE_TTL", 30*time.Second),
+		expiringWindow:    getEnvDuration("STATS_EXPIRING_WINDOW", 7*24*time.Hour),
+		acknowledgeWindow: getEnvDuration("STATS_ACKNOWLEDGE_WINDOW", 30*24*time.Hour),
+	}
+}
+
+func (c *statsCache) Get(ctx context.Context) (DashboardStats, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if now.Before(c.expires) {
+		return c.value, nil
+	}
+
+	stats, err := c.source.DashboardStats(ctx, statsQuery{now: now, expiringWindow: c.expiringWindow, acknowledgeWindow: c.acknowledgeWindow})
+	if err != nil {
+		return DashboardStats{}, err
+	}
+	if stats.OpenIncidentsBySeverity == nil {
+		stats.OpenIncidentsBySeverity = map[string]int{}
+	}
+	stats.OpenIncidents = 0
+	for _, count := range stats.OpenIncidentsBySeverity {
+		stats.OpenIncidents += count
+	}
+	stats.GeneratedAt = now.UTC().Format(time.RFC3339)
+	stats.Source = c.source.Name()
+	stats.AcknowledgeWindow = c.acknowledgeWindow.String()
+	stats.DIDs.ExpiringWindow = c.expiringWindow.String()
+
+	c.value = stats
+	c.expires = now.Add(c.ttl)
+	return stats, nil
+}
+
+// DashboardStats returns the cached dashboard summary, refreshing it when it has expired
+func (ledgerService) DashboardStats(ctx context.Context) (DashboardStats, error) {
+	if isDefaultTarget(ctx) {
+		return dashboardStats.Get(ctx)
+	}
+	cache, _ := targetStats.LoadOrStore(targetFromContext(ctx).Name, &statsCache{
+		source:            ledgerStatsSource{},
+		ttl:               dashboardStats.ttl,
+		expiringWindow:    dashboardStats.expiringWindow,
+		acknowledgeWindow: dashboardStats.acknowledgeWindow,
+	})
+	return cache.(*statsCache).Get(ctx)
+}
+
+func getStats(c *gin.Context) {
+	stats, err := ledger.DashboardStats(c.Request.Context())
+	if err != nil {
+		respondServiceError(c, "Failed to compute statistics", err)
+		return
+	}
+
+	respondData(c, http.StatusOK, stats)
+}
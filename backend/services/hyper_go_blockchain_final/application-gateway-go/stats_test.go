@@ -0,0 +1,61 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type fakeStatsSource struct {
+	calls int
+	err   error
+}
+
+func (f *fakeStatsSource) Name() string {
+	return "fake"
+}
+
+func (f *fakeStatsSource) DashboardStats(ctx context.Context, query statsQuery) (DashboardStats, error) {
+	f.calls++
+	if f.err != nil {
+		return DashboardStats{}, f.err
+	}
+	return DashboardStats{OpenIncidentsBySeverity: map[string]int{"high": 2, "critical": 1}, SOSLast24h: f.calls}, nil
+}
+
+func TestStatsCache(t *testing.T) {
+	source := &fakeStatsSource{}
+	cache := &statsCache{source: source, ttl: time.Minute, expiringWindow: 7 * 24 * time.Hour, acknowledgeWindow: time.Hour}
+
+	stats, err := cache.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.OpenIncidents != 3 || stats.Source != "fake" || stats.DIDs.ExpiringWindow != "168h0m0s" || stats.GeneratedAt == "" {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	if _, err := cache.Get(context.Background()); err != nil || source.calls != 1 {
+		t.Fatalf("expected a cached result, source called %d times (%v)", source.calls, err)
+	}
+
+	cache.expires = time.Now().Add(-time.Second)
+	stats, _ = cache.Get(context.Background())
+	if source.calls != 2 || stats.SOSLast24h != 2 {
+		t.Errorf("expected a refresh after expiry, source called %d times", source.calls)
+	}
+}
+
+func TestStatsCacheDoesNotCacheErrors(t *testing.T) {
+	source := &fakeStatsSource{err: errors.New("peer unavailable")}
+	cache := &statsCache{source: source, ttl: time.Minute}
+
+	if _, err := cache.Get(context.Background()); err == nil {
+		t.Fatal("expected an error")
+	}
+	source.err = nil
+	if _, err := cache.Get(context.Background()); err != nil || source.calls != 2 {
+		t.Fatalf("expected a retry after a failure, source called %d times (%v)", source.calls, err)
+	}
+}
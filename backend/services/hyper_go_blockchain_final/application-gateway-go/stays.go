@@ -0,0 +1,436 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	errCodeStaysDisabled = "STAYS_DISABLED"
+
+	stayCheckedIn  = "checked_in"
+	stayCheckedOut = "checked_out"
+	stayCheckIn    = "check_in"
+	stayCheckOut   = "check_out"
+	// stayLocationAccuracy is the accuracy, in metres, of a position taken from a property
+	stayLocationAccuracy = 50
+	maxStayReportAge     = 30 * 24 * time.Hour
+	maxStayWebhookBody   = 64 << 10
+)
+
+// AccommodationProperty is a hotel or homestay a provider reports stays at. Its position becomes the
+// tourist's live location when they check in or out.
+type AccommodationProperty struct {
+	PropertyID string  `json:"property_id"`
+	Name       string  `json:"name"`
+	Latitude   float64 `json:"latitude"`
+	Longitude  float64 `json:"longitude"`
+}
+
+// AccommodationProvider is a hotel, homestay network or booking platform allowed to report stays.
+// WebhookSecretEnv names the environment variable holding the secret its webhooks are signed with;
+// a provider without one reports through the API only.
+type AccommodationProvider struct {
+	ProviderID       string                  `json:"provider_id"`
+	Name             string                  `json:"name"`
+	WebhookSecretEnv string                  `json:"webhook_secret_env,omitempty"`
+	Properties       []AccommodationProperty `json:"properties"`
+
+	secret     []byte
+	properties map[string]AccommodationProperty
+}
+
+// StayCheckInRequest reports a tourist's arrival at a property. RegisterHash is the SHA-256 of the
+// provider's guest register entry, such as the Form C record of a foreign national.
+type StayCheckInRequest struct {
+	StayID       string `json:"stayID" binding:"required"`
+	DigitalID    string `json:"digitalID" binding:"required"`
+	ProviderID   string `json:"providerID" binding:"required"`
+	PropertyID   string `json:"propertyID" binding:"required"`
+	CheckedInAt  string `json:"checkedInAt" binding:"required"`
+	RegisterHash string `json:"registerHash" binding:"required"`
+}
+
+func (r StayCheckInRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.identifier("stayID", r.StayID)
+	v.digitalID("digitalID", r.DigitalID)
+	v.identifier("providerID", r.ProviderID)
+	v.identifier("propertyID", r.PropertyID)
+	validateStayTime(&v, "checkedInAt", r.CheckedInAt)
+	v.sha256("registerHash", r.RegisterHash)
+	return v.errors
+}
+
+// StayCheckOutRequest reports a tourist's departure. RegisterHash is the SHA-256 of the completed
+// register entry.
+type StayCheckOutRequest struct {
+	StayID       string `json:"stayID" binding:"required"`
+	ProviderID   string `json:"providerID" binding:"required"`
+	CheckedOutAt string `json:"checkedOutAt" binding:"required"`
+	RegisterHash string `json:"registerHash" binding:"required"`
+}
+
+func (r StayCheckOutRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.identifier("stayID", r.StayID)
+	v.identifier("providerID", r.ProviderID)
+	validateStayTime(&v, "checkedOutAt", r.CheckedOutAt)
+	v.sha256("registerHash", r.RegisterHash)
+	return v.errors
+}
+
+// validateStayTime accepts reports up to maxStayReportAge late, since guest registers are often
+// submitted at the end of the day
+func validateStayTime(v *fieldValidator, field, value string) {
+	t, ok := v.rfc3339(field, value)
+	switch {
+	case !ok:
+	case t.After(time.Now().Add(maxLocationPingClockSkew)):
+		v.add(field, "must not be in the future")
+	case t.Before(time.Now().Add(-maxStayReportAge)):
+		v.add(field, "must be within the last %s", maxStayReportAge)
+	}
+}
+
+// StayDocument is a stay as recorded on the ledger
+type StayDocument struct {
+	DocType      string `json:"doc_type"`
+	StayID       string `json:"stay_id"`
+	DigitalID    string `json:"digital_id"`
+	ProviderID   string `json:"provider_id"`
+	PropertyID   string `json:"property_id"`
+	Geohash      string `json:"geohash"`
+	Status       string `json:"status"`
+	CheckedInAt  string `json:"checked_in_at"`
+	CheckedOutAt string `json:"checked_out_at,omitempty"`
+	RecordHash   string `json:"record_hash"`
+	ReportedBy   string `json:"reported_by"`
+	OwnerOrg     string `json:"owner_org,omitempty"`
+	TxID         string `json:"tx_id"`
+}
+
+// StayReport acknowledges a check-in or check-out. LocationUpdated is false when the property's
+// position could not be recorded, for example because the tourist has not consented to location
+// tracking.
+type StayReport struct {
+	StayID          string `json:"stay_id"`
+	Status          string `json:"status"`
+	LocationUpdated bool   `json:"location_updated"`
+	// Duplicate is set when a webhook repeats a report already on the ledger
+	Duplicate bool `json:"duplicate,omitempty"`
+}
+
+// StayWebhookEvent is the body providers post to /callbacks/accommodation/:providerId. Event is
+// check_in or check_out; DigitalID and PropertyID are needed for a check-in only.
+type StayWebhookEvent struct {
+	Event        string `json:"event"`
+	StayID       string `json:"stayID"`
+	DigitalID    string `json:"digitalID,omitempty"`
+	PropertyID   string `json:"propertyID,omitempty"`
+	At           string `json:"at"`
+	RegisterHash string `json:"registerHash"`
+}
+
+type accommodationService struct {
+	providers map[string]*AccommodationProvider
+	// tolerance is how far a webhook's signed timestamp may be from our clock
+	tolerance time.Duration
+}
+
+var accommodation *accommodationService
+
+// initStays loads the providers allowed to report stays. Runs after initLocation, which check-ins and
+// check-outs are forwarded to.
+func initStays() {
+	path := getEnv("ACCOMMODATION_PROVIDERS_FILE", "")
+	if path == "" {
+		log.Println("🏨 ACCOMMODATION_PROVIDERS_FILE not set, stay reporting disabled")
+		return
+	}
+	providers, err := readAccommodationProviders(path)
+	if err != nil {
+		panic(err)
+	}
+	tolerance := getEnvDuration("ACCOMMODATION_SIGNATURE_TOLERANCE", 5*time.Minute)
+	if tolerance <= 0 {
+		panic(fmt.Errorf("ACCOMMODATION_SIGNATURE_TOLERANCE must be positive"))
+	}
+	accommodation = &accommodationService{providers: providers, tolerance: tolerance}
+	log.Printf("🏨 Accepting stays from %d accommodation providers", len(providers))
+}
+
+// readAccommodationProviders loads the ACCOMMODATION_PROVIDERS_FILE registry and each provider's
+// webhook secret
+func readAccommodationProviders(path string) (map[string]*AccommodationProvider, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read accommodation providers: %w", err)
+	}
+	var list []*AccommodationProvider
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("failed to parse accommodation providers: %w", err)
+	}
+	providers := make(map[string]*AccommodationProvider, len(list))
+	for _, p := range list {
+		if len(validateDocumentID("provider_id", p.ProviderID)) > 0 || providers[p.ProviderID] != nil {
+			return nil, fmt.Errorf("accommodation provider %q is not a unique identifier", p.ProviderID)
+		}
+		if p.WebhookSecretEnv != "" {
+			if p.secret = []byte(os.Getenv(p.WebhookSecretEnv)); len(p.secret) == 0 {
+				return nil, fmt.Errorf("accommodation provider %s needs its webhook secret in %s", p.ProviderID, p.WebhookSecretEnv)
+			}
+		}
+		p.properties = make(map[string]AccommodationProperty, len(p.Properties))
+		for _, property := range p.Properties {
+			if len(validateDocumentID("property_id", property.PropertyID)) > 0 {
+				return nil, fmt.Errorf("accommodation provider %s has property %q, which is not an identifier", p.ProviderID, property.PropertyID)
+			}
+			if _, ok := p.properties[property.PropertyID]; ok {
+				return nil, fmt.Errorf("accommodation provider %s lists property %s twice", p.ProviderID, property.PropertyID)
+			}
+			if property.Latitude < -90 || property.Latitude > 90 || property.Longitude < -180 || property.Longitude > 180 {
+				return nil, fmt.Errorf("property %s of %s has an invalid position", property.PropertyID, p.ProviderID)
+			}
+			p.properties[property.PropertyID] = property
+		}
+		providers[p.ProviderID] = p
+	}
+	return providers, nil
+}
+
+// property resolves the provider and property a report names
+func (s *accommodationService) property(providerID, propertyID string) (AccommodationProperty, error) {
+	provider, ok := s.providers[providerID]
+	if !ok {
+		return AccommodationProperty{}, ValidationErrors{{Field: "providerID", Message: "is not a registered accommodation provider"}}
+	}
+	property, ok := provider.properties[propertyID]
+	if !ok {
+		return AccommodationProperty{}, ValidationErrors{{Field: "propertyID", Message: "is not a property of " + providerID}}
+	}
+	return property, nil
+}
+
+// CheckInStay anchors a check-in and makes the property the tourist's last known location
+func (ledgerService) CheckInStay(ctx context.Context, req StayCheckInRequest) (*StayReport, *TransactionResult, error) {
+	if errs := req.Validate(); len(errs) > 0 {
+		return nil, nil, errs
+	}
+	property, err := accommodation.property(req.ProviderID, req.PropertyID)
+	if err != nil {
+		return nil, nil, err
+	}
+	checkedInAt := ledgerTimestamp(req.CheckedInAt)
+	result, err := submitTransaction(ctx, "CheckInStay", req.StayID, req.DigitalID, req.ProviderID, req.PropertyID,
+		encodeGeohash(property.Latitude, property.Longitude, ledgerGeohashPrecision), checkedInAt, req.RegisterHash, req.ProviderID)
+	if err != nil {
+		return nil, nil, err
+	}
+	report := &StayReport{StayID: req.StayID, Status: stayCheckedIn, LocationUpdated: recordStayLocation(ctx, req.DigitalID, property, checkedInAt)}
+	return report, result, nil
+}
+
+// CheckOutStay closes a stay. The tourist was last seen at the property when they checked out.
+func (ledgerService) CheckOutStay(ctx context.Context, req StayCheckOutRequest) (*StayReport, *TransactionResult, error) {
+	if errs := req.Validate(); len(errs) > 0 {
+		return nil, nil, errs
+	}
+	stay, err := ledger.GetStay(ctx, req.StayID)
+	if err != nil {
+		return nil, nil, err
+	}
+	checkedOutAt := ledgerTimestamp(req.CheckedOutAt)
+	result, err := submitAndInvalidate(ctx, req.StayID, "CheckOutStay", req.StayID, req.ProviderID, checkedOutAt, req.RegisterHash, req.ProviderID)
+	if err != nil {
+		return nil, nil, err
+	}
+	report := &StayReport{StayID: req.StayID, Status: stayCheckedOut}
+	// A property since removed from the registry leaves the live location as it was
+	if property, err := accommodation.property(stay.ProviderID, stay.PropertyID); err == nil {
+		report.LocationUpdated = recordStayLocation(ctx, stay.DigitalID, property, checkedOutAt)
+	}
+	return report, result, nil
+}
+
+// recordStayLocation records the property's position as a ping at the time of the report, so it
+// becomes the tourist's live location unless the app has reported a later one
+func recordStayLocation(ctx context.Context, digitalID string, property AccommodationProperty, at string) bool {
+	if locations == nil {
+		return false
+	}
+	latitude, longitude := property.Latitude, property.Longitude
+	ping := LocationPing{Latitude: &latitude, Longitude: &longitude, Accuracy: stayLocationAccuracy, RecordedAt: at}
+	if _, err := locations.Ingest(ctx, LocationPingsRequest{DigitalID: digitalID, Pings: []LocationPing{ping}}); err != nil {
+		logWithContext(ctx, "🏨 Failed to record the position of %s at %s: %v", digitalID, property.PropertyID, err)
+		return false
+	}
+	return true
+}
+
+func (ledgerService) GetStay(ctx context.Context, stayID string) (StayDocument, error) {
+	if errs := validateDocumentID("stayID", stayID); len(errs) > 0 {
+		return StayDocument{}, errs
+	}
+	result, err := readDocument(ctx, "ReadStay", stayID)
+	if err != nil {
+		return StayDocument{}, err
+	}
+	return decodeDocument[StayDocument](result, "stay")
+}
+
+// ListStays returns a tourist's stays, latest check-in first
+func (ledgerService) ListStays(ctx context.Context, digitalID string) ([]StayDocument, error) {
+	if errs := validateDocumentID("digitalId", digitalID); len(errs) > 0 {
+		return nil, errs
+	}
+	result, err := evaluateTransaction(ctx, "GetStaysByDID", digitalID)
+	if err != nil {
+		return nil, err
+	}
+	stays, err := decodeDocument[[]StayDocument](result, "stay")
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(stays, func(i, j int) bool { return stays[i].CheckedInAt > stays[j].CheckedInAt })
+	return stays, nil
+}
+
+// duplicateReport reports whether a webhook event repeats what the ledger already records, as when
+// a provider redelivers a webhook whose response it did not receive
+func duplicateReport(ctx context.Context, providerID string, event StayWebhookEvent) *StayReport {
+	stay, err := ledger.GetStay(ctx, event.StayID)
+	if err != nil || stay.ProviderID != providerID {
+		return nil
+	}
+	at := ledgerTimestamp(event.At)
+	switch {
+	case event.Event == stayCheckIn && stay.DigitalID == event.DigitalID && stay.CheckedInAt == at:
+	case event.Event == stayCheckOut && stay.Status == stayCheckedOut && stay.CheckedOutAt == at:
+	default:
+		return nil
+	}
+	return &StayReport{StayID: stay.StayID, Status: stay.Status, Duplicate: true}
+}
+
+func checkInStay(c *gin.Context) {
+	if accommodation == nil {
+		respondError(c, http.StatusServiceUnavailable, errCodeStaysDisabled, "Accommodation providers are not configured")
+		return
+	}
+	var req StayCheckInRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+	setAuditTarget(c, req.StayID)
+
+	report, result, err := ledger.CheckInStay(c.Request.Context(), req)
+	if err != nil {
+		respondServiceError(c, "Failed to record check-in", err)
+		return
+	}
+	respondCommitted(c, http.StatusCreated, report, result)
+}
+
+func checkOutStay(c *gin.Context) {
+	if accommodation == nil {
+		respondError(c, http.StatusServiceUnavailable, errCodeStaysDisabled, "Accommodation providers are not configured")
+		return
+	}
+	var req StayCheckOutRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+	setAuditTarget(c, req.StayID)
+
+	report, result, err := ledger.CheckOutStay(c.Request.Context(), req)
+	if err != nil {
+		respondServiceError(c, "Failed to record check-out", err)
+		return
+	}
+	respondCommitted(c, http.StatusOK, report, result)
+}
+
+func listStays(c *gin.Context) {
+	id, ok := validPathID(c, "digitalId")
+	if !ok {
+		return
+	}
+	stays, err := ledger.ListStays(c.Request.Context(), id)
+	if err != nil {
+		respondServiceError(c, "Failed to list stays", err)
+		return
+	}
+	respondData(c, http.StatusOK, stays)
+}
+
+// stayWebhook receives check-ins and check-outs from providers that cannot call the API. It sits
+// outside /api/v1 because it is authenticated by the provider's signature rather than an API key.
+// The signature covers a timestamp that must be within ACCOMMODATION_SIGNATURE_TOLERANCE of our
+// clock, so a captured webhook cannot be replayed later.
+func stayWebhook(c *gin.Context) {
+	if accommodation == nil {
+		respondError(c, http.StatusServiceUnavailable, errCodeStaysDisabled, "Accommodation providers are not configured")
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxStayWebhookBody+1))
+	if err != nil || len(body) > maxStayWebhookBody {
+		respondError(c, http.StatusBadRequest, errCodeValidation, "The webhook body could not be read")
+		return
+	}
+	provider, ok := accommodation.providers[c.Param("providerId")]
+	timestamp := c.GetHeader(signatureTimestampHeader)
+	if !ok || provider.secret == nil || !validWebhookSignature(provider.secret, timestamp, body, c.GetHeader(signatureHeader)) ||
+		!freshWebhookTimestamp(timestamp, time.Now(), accommodation.tolerance) {
+		respondError(c, http.StatusForbidden, errCodeAccessDenied, "Webhook signature verification failed")
+		return
+	}
+	var event StayWebhookEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		respondError(c, http.StatusBadRequest, errCodeValidation, "The webhook body is not a stay event")
+		return
+	}
+
+	ctx := c.Request.Context()
+	var report *StayReport
+	var result *TransactionResult
+	switch event.Event {
+	case stayCheckIn:
+		report, result, err = ledger.CheckInStay(ctx, StayCheckInRequest{StayID: event.StayID, DigitalID: event.DigitalID, ProviderID: provider.ProviderID,
+			PropertyID: event.PropertyID, CheckedInAt: event.At, RegisterHash: event.RegisterHash})
+	case stayCheckOut:
+		report, result, err = ledger.CheckOutStay(ctx, StayCheckOutRequest{StayID: event.StayID, ProviderID: provider.ProviderID,
+			CheckedOutAt: event.At, RegisterHash: event.RegisterHash})
+	default:
+		respondValidationErrors(c, ValidationErrors{{Field: "event", Message: "must be one of check_in, check_out"}})
+		return
+	}
+	var validationErrs ValidationErrors
+	if err != nil && !errors.As(err, &validationErrs) {
+		if duplicate := duplicateReport(ctx, provider.ProviderID, event); duplicate != nil {
+			respondData(c, http.StatusOK, duplicate)
+			return
+		}
+	}
+	if err != nil {
+		respondServiceError(c, "Failed to record stay", err)
+		return
+	}
+	respondCommitted(c, http.StatusOK, report, result)
+}
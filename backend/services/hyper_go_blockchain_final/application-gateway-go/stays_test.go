@@ -0,0 +1,129 @@
+package gateway
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestReadAccommodationProviders(t *testing.T) {
+	t.Setenv("STAY_TEST_SECRET", "s3cret")
+	write := func(content string) string {
+		path := filepath.Join(t.TempDir(), "providers.json")
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	providers, err := readAccommodationProviders(write(`[{"provider_id":"hotel_pinewood","name":"Pinewood Hotels","webhook_secret_env":"STAY_TEST_SECRET",
+		"properties":[{"property_id":"shillong_1","name":"Pinewood Shillong","latitude":25.5788,"longitude":91.8933}]}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := providers["hotel_pinewood"]; p == nil || string(p.secret) != "s3cret" || p.properties["shillong_1"].Latitude != 25.5788 {
+		t.Errorf("unexpected providers %+v", providers)
+	}
+
+	for name, content := range map[string]string{
+		"duplicate provider": `[{"provider_id":"a","properties":[]},{"provider_id":"a","properties":[]}]`,
+		"missing secret":     `[{"provider_id":"a","webhook_secret_env":"STAY_TEST_UNSET","properties":[]}]`,
+		"duplicate property": `[{"provider_id":"a","properties":[{"property_id":"p","latitude":1,"longitude":1},{"property_id":"p","latitude":1,"longitude":1}]}]`,
+		"invalid position":   `[{"provider_id":"a","properties":[{"property_id":"p","latitude":95,"longitude":1}]}]`,
+	} {
+		if _, err := readAccommodationProviders(write(content)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestStayRequestValidation(t *testing.T) {
+	hash := strings.Repeat("a", 64)
+	valid := StayCheckInRequest{StayID: "stay_1", DigitalID: "did:sih:tourist_001", ProviderID: "hotel_pinewood", PropertyID: "shillong_1",
+		CheckedInAt: time.Now().Add(-time.Hour).Format(time.RFC3339), RegisterHash: hash}
+	if errs := valid.Validate(); len(errs) > 0 {
+		t.Fatalf("unexpected errors %v", errs)
+	}
+	late := valid
+	late.CheckedInAt = time.Now().Add(-maxStayReportAge - time.Hour).Format(time.RFC3339)
+	future := valid
+	future.CheckedInAt = time.Now().Add(time.Hour).Format(time.RFC3339)
+	for _, req := range []StayCheckInRequest{late, future} {
+		if errs := req.Validate(); len(errs) != 1 || errs[0].Field != "checkedInAt" {
+			t.Errorf("expected checkedInAt rejected for %s, got %v", req.CheckedInAt, errs)
+		}
+	}
+	if errs := (StayCheckOutRequest{StayID: "stay_1", ProviderID: "hotel_pinewood", CheckedOutAt: "yesterday", RegisterHash: "abc"}).Validate(); len(errs) != 2 {
+		t.Errorf("expected the time and hash rejected, got %v", errs)
+	}
+}
+
+func TestStayWebhookSignature(t *testing.T) {
+	provider := &AccommodationProvider{ProviderID: "hotel_pinewood", secret: []byte("s3cret")}
+	accommodation = &accommodationService{providers: map[string]*AccommodationProvider{provider.ProviderID: provider}, tolerance: 5 * time.Minute}
+	defer func() { accommodation = nil }()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/callbacks/accommodation/:providerId", stayWebhook)
+	post := func(providerID, body, timestamp, signature string) int {
+		req := httptest.NewRequest(http.MethodPost, "/callbacks/accommodation/"+providerID, strings.NewReader(body))
+		req.Header.Set(signatureTimestampHeader, timestamp)
+		req.Header.Set(signatureHeader, signature)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	body := `{"event":"checked_in","stayID":"stay_1"}`
+	sign := func(timestamp string) string {
+		mac := hmac.New(sha256.New, provider.secret)
+		mac.Write([]byte(timestamp + "." + body))
+		return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	stale := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+
+	if code := post("hotel_pinewood", body, now, "sha256=forged"); code != http.StatusForbidden {
+		t.Errorf("expected a forged webhook rejected, got %d", code)
+	}
+	if code := post("hotel_other", body, now, sign(now)); code != http.StatusForbidden {
+		t.Errorf("expected an unknown provider rejected, got %d", code)
+	}
+	if code := post("hotel_pinewood", body, stale, sign(stale)); code != http.StatusForbidden {
+		t.Errorf("expected a replayed webhook with a stale timestamp rejected, got %d", code)
+	}
+	if code := post("hotel_pinewood", body, "", sign("")); code != http.StatusForbidden {
+		t.Errorf("expected a webhook without a timestamp rejected, got %d", code)
+	}
+	// A signed event reaches validation, which rejects the unknown event type
+	if code := post("hotel_pinewood", body, now, sign(now)); code != http.StatusBadRequest {
+		t.Errorf("expected the signed event validated, got %d", code)
+	}
+}
+
+func TestRecordStayLocation(t *testing.T) {
+	previous := locations
+	locations = &locationService{store: newMemoryLocationStore(time.Hour)}
+	defer func() { locations = previous }()
+	ctx := context.Background()
+	property := AccommodationProperty{PropertyID: "shillong_1", Latitude: 25.5788, Longitude: 91.8933}
+
+	if !recordStayLocation(ctx, "did:sih:tourist_001", property, time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)) {
+		t.Fatal("expected the property recorded as the live location")
+	}
+	live, _ := locations.store.latest(ctx, "did:sih:tourist_001")
+	if live == nil || live.Latitude != property.Latitude || live.Accuracy != stayLocationAccuracy {
+		t.Errorf("unexpected live location %+v", live)
+	}
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// errObjectNotFound is returned by an EvidenceStore when the key has no stored object
+var errObjectNotFound = errors.New("object not found")
+
+// EvidenceStore persists evidence binaries outside the ledger; only their hashes are anchored on-chain
+type EvidenceStore interface {
+	Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+var evidenceStore EvidenceStore
+
+// initEvidenceStore selects the storage backend from EVIDENCE_STORAGE
+func initEvidenceStore() {
+	backend := getEnv("EVIDENCE_STORAGE", "local")
+	switch backend {
+	case "local":
+		dir := getEnv("EVIDENCE_STORAGE_DIR", "./evidence-store")
+		store, err := newLocalStore(dir)
+		if err != nil {
+			panic(fmt.Errorf("failed to initialise local evidence store: %w", err))
+		}
+		evidenceStore = store
+		log.Printf("🗄️  Storing evidence files in %s", dir)
+	case "s3", "minio":
+		store, err := newS3StoreFromEnv()
+		if err != nil {
+			panic(fmt.Errorf("failed to initialise S3 evidence store: %w", err))
+		}
+		evidenceStore = store
+		log.Printf("🗄️  Storing evidence files in bucket %s at %s", store.bucket, store.endpoint)
+	case "ipfs":
+		store := newIPFSStoreFromEnv()
+		evidenceStore = store
+		log.Printf("🗄️  Storing evidence files in IPFS via %s under %s", store.apiURL, store.root)
+	default:
+		panic(fmt.Errorf("unknown EVIDENCE_STORAGE backend %q", backend))
+	}
+}
+
+// localStore keeps evidence files on the local filesystem
+type localStore struct {
+	dir string
+}
+
+func newLocalStore(dir string) (*localStore, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	return &localStore{dir: dir}, nil
+}
+
+func (s *localStore) path(key string) (string, error) {
+	cleaned := filepath.Clean("/" + key)
+	if strings.Contains(key, "..") || cleaned == "/" {
+		return "", fmt.Errorf("invalid object key %q", key)
+	}
+	return filepath.Join(s.dir, cleaned), nil
+}
+
+// Put writes to a temporary file first so a failed upload never leaves a partial object behind
+func (s *localStore) Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error {
+	target, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0o750); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(target), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, body); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), target)
+}
+
+func (s *localStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	target, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(target)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errObjectNotFound
+	}
+	return file, err
+}
+
+func (s *localStore) Delete(ctx context.Context, key string) error {
+	target, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(target); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
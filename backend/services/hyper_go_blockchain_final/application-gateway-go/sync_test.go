@@ -0,0 +1,101 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyncRequestValidation(t *testing.T) {
+	queuedAt := time.Now().UTC().Format(time.RFC3339)
+	op := SyncOperation{OpID: "op-1", Type: syncSOS, Clock: 1, QueuedAt: queuedAt, Payload: json.RawMessage(`{}`)}
+	req := SyncRequest{DigitalID: "did:sih:tourist_001", DeviceID: "phone-1", Operations: []SyncOperation{op, op}}
+	errs := req.Validate()
+	if len(errs) != 2 || !strings.Contains(errs.Error(), "operations[1].opID") || !strings.Contains(errs.Error(), "operations[1].clock") {
+		t.Errorf("expected the repeated op ID and clock rejected, got %v", errs)
+	}
+
+	op.Type, op.Clock = "checkin", 0
+	if errs := (SyncRequest{DigitalID: "did:sih:tourist_001", DeviceID: "phone-1", Operations: []SyncOperation{op}}).Validate(); len(errs) != 2 {
+		t.Errorf("expected the type and clock rejected, got %v", errs)
+	}
+	if errs := (SyncRequest{DigitalID: "did:sih:tourist_001", DeviceID: "phone-1"}).Validate(); len(errs) != 1 {
+		t.Errorf("expected an empty queue rejected, got %v", errs)
+	}
+}
+
+func TestSyncApplyPings(t *testing.T) {
+	previous := locations
+	locations = &locationService{store: newMemoryLocationStore(time.Hour)}
+	defer func() { locations = previous }()
+	s := &syncService{store: newMemorySyncStore(), retention: 24 * time.Hour}
+	ctx := context.Background()
+	now := time.Now()
+
+	payload := fmt.Sprintf(`{"pings":[{"latitude":25.5788,"longitude":91.8933,"accuracy":8,"recordedAt":%q}]}`, now.Add(-2*time.Hour).UTC().Format(time.RFC3339))
+	op := SyncOperation{OpID: "op-1", Type: syncLocationPings, Clock: 1, QueuedAt: now.Add(-2 * time.Hour).UTC().Format(time.RFC3339), Payload: json.RawMessage(payload)}
+	result := s.apply(ctx, "did:sih:tourist_001", op)
+	if result.Status != syncApplied || result.RecordID != "did:sih:tourist_001" || len(result.Server) == 0 {
+		t.Fatalf("expected the pings applied, got %+v", result)
+	}
+	if live, _ := locations.store.latest(ctx, "did:sih:tourist_001"); live == nil || live.Longitude != 91.8933 {
+		t.Errorf("expected the queued fix to become the live location, got %+v", live)
+	}
+
+	op.Payload = json.RawMessage(strings.Replace(payload, `{"pings"`, `{"digitalID":"did:sih:tourist_002","pings"`, 1))
+	if result := s.apply(ctx, "did:sih:tourist_001", op); result.Status != syncRejected || result.Code != errCodeSyncMismatch {
+		t.Errorf("expected pings for another tourist rejected, got %+v", result)
+	}
+	op.Payload = json.RawMessage(`[]`)
+	if result := s.apply(ctx, "did:sih:tourist_001", op); result.Status != syncRejected || result.Code != errCodeValidation {
+		t.Errorf("expected a malformed payload rejected, got %+v", result)
+	}
+
+	op.QueuedAt = now.Add(-48 * time.Hour).UTC().Format(time.RFC3339)
+	if result := s.apply(ctx, "did:sih:tourist_001", op); result.Code != errCodeStaleOperation {
+		t.Errorf("expected an operation older than the retention rejected, got %+v", result)
+	}
+	op.QueuedAt = now.Add(time.Hour).UTC().Format(time.RFC3339)
+	if result := s.apply(ctx, "did:sih:tourist_001", op); result.Code != errCodeStaleOperation {
+		t.Errorf("expected an operation from the future rejected, got %+v", result)
+	}
+}
+
+func TestSyncResultFail(t *testing.T) {
+	var result SyncResult
+	result.fail(&consentRequiredError{digitalID: "did:sih:tourist_001", purpose: purposeLocationTracking})
+	if result.Status != syncRejected || result.Code != errCodeConsentRequired {
+		t.Errorf("expected a missing consent rejected, got %+v", result)
+	}
+	result = SyncResult{}
+	result.fail(errors.New("connection refused"))
+	if result.Status != syncFailed {
+		t.Errorf("expected a transient error to leave the operation for retry, got %+v", result)
+	}
+}
+
+func TestMemorySyncStore(t *testing.T) {
+	ctx := context.Background()
+	s := newMemorySyncStore()
+
+	s.put(ctx, "kept", syncRecord{Fingerprint: "a"}, time.Hour)
+	s.put(ctx, "expired", syncRecord{Fingerprint: "b"}, -time.Second)
+	if rec, _ := s.get(ctx, "kept"); rec == nil || rec.Fingerprint != "a" {
+		t.Errorf("expected the record kept, got %+v", rec)
+	}
+	if rec, _ := s.get(ctx, "expired"); rec != nil {
+		t.Errorf("expected the expired record gone, got %+v", rec)
+	}
+
+	s.advance(ctx, "did:sih:tourist_001", "phone-1", 5)
+	s.advance(ctx, "did:sih:tourist_001", "phone-1", 3)
+	s.advance(ctx, "did:sih:tourist_001", "band-7", 2)
+	vector, _ := s.vector(ctx, "did:sih:tourist_001")
+	if len(vector) != 2 || vector["phone-1"] != 5 || vector["band-7"] != 2 {
+		t.Errorf("expected the vector never lowered, got %v", vector)
+	}
+}
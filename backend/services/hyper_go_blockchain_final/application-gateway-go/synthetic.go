@@ -0,0 +1,867 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultSynthWindow       = 24 * time.Hour
+	defaultSynthPingInterval = 5 * time.Minute
+	defaultSynthConcurrency  = 20
+	minSynthWindow           = 6 * time.Hour
+	// The gateway rejects pings older than maxLocationPingAge, so an hour is left to submit the trace
+	maxSynthWindow    = maxLocationPingAge - time.Hour
+	maxSynthTourists  = 100000
+	synthTravelKmh    = 30.0
+	synthStopRadiusKm = 1.0
+	synthStayMeters   = 300.0
+	synthWanderMeters = 40.0
+	synthDIDValidity  = 30 * 24 * time.Hour
+)
+
+// Injected anomalies are sized to trip the anomaly detector at its default settings
+const (
+	synthDeviationMeters = 6000.0
+	synthDeviationFor    = 90 * time.Minute
+	synthInactivityFor   = 3 * time.Hour
+	synthSignalLossFor   = 45 * time.Minute
+	synthJumpMeters      = 40000.0
+)
+
+// Operations reported by sih-synth
+const (
+	synthOpDID            = "did"
+	synthOpConsent        = "consent"
+	synthOpItinerary      = "itinerary"
+	synthOpPings          = "pings"
+	synthOpIncident       = "incident"
+	synthOpIncidentStatus = "incident_status"
+	synthOpSOS            = "sos"
+)
+
+var synthAnomalyTypes = []string{anomalyRouteDeviation, anomalyInactivity, anomalySignalLoss, anomalySpeedJump}
+
+// Synthetic incidents are the kinds people report; anomaly and geofence incidents are raised by
+// the gateway itself from the generated traces
+var (
+	synthIncidentCategories = []string{"missing_person", "medical", "accident", "theft", "harassment", "other"}
+	synthSeverityWeights    = []float64{0.4, 0.3, 0.2, 0.1}
+)
+
+// synthScenario describes the synthetic tourists to generate: where they travel, how many there
+// are and how often things go wrong. Durations are Go duration strings.
+type synthScenario struct {
+	Name    string `json:"name"`
+	BaseURL string `json:"baseURL"`
+	// DIDMethod and Issuer shape the DIDs issued to synthetic tourists
+	DIDMethod string `json:"didMethod,omitempty"`
+	Issuer    string `json:"issuer,omitempty"`
+	// Seed makes the generated data repeatable
+	Seed uint64 `json:"seed,omitempty"`
+	// Window is how long before now the generated activity starts, and PingInterval the mean
+	// spacing of each tourist's pings
+	Window       string        `json:"window,omitempty"`
+	PingInterval string        `json:"pingInterval,omitempty"`
+	Timeout      string        `json:"timeout,omitempty"`
+	Concurrency  int           `json:"concurrency,omitempty"`
+	Regions      []synthRegion `json:"regions"`
+	Volume       synthVolume   `json:"volume"`
+}
+
+// synthRegion is a destination tourists are spread over by Weight. Their itineraries visit Places,
+// or random points within the region when it lists fewer than two.
+type synthRegion struct {
+	Name         string       `json:"name"`
+	Latitude     float64      `json:"latitude"`
+	Longitude    float64      `json:"longitude"`
+	RadiusMeters float64      `json:"radiusMeters"`
+	Weight       float64      `json:"weight,omitempty"`
+	Places       []synthPlace `json:"places,omitempty"`
+}
+
+type synthPlace struct {
+	Name      string  `json:"name"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// synthVolume is how much to generate. Incidents and SOS alerts are drawn per tourist-day, and
+// AnomalyRate is the share of tourists whose trace carries one anomaly of AnomalyTypes.
+type synthVolume struct {
+	Tourists               int      `json:"tourists"`
+	IncidentsPerTouristDay float64  `json:"incidentsPerTouristDay"`
+	SOSPerTouristDay       float64  `json:"sosPerTouristDay"`
+	AnomalyRate            float64  `json:"anomalyRate"`
+	AnomalyTypes           []string `json:"anomalyTypes,omitempty"`
+}
+
+func (s *synthScenario) window() time.Duration {
+	d, _ := time.ParseDuration(s.Window)
+	return d
+}
+
+func (s *synthScenario) pingInterval() time.Duration {
+	d, _ := time.ParseDuration(s.PingInterval)
+	return d
+}
+
+func readSynthScenario(filename string) (*synthScenario, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read scenario: %w", err)
+	}
+	var s synthScenario
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&s); err != nil {
+		return nil, fmt.Errorf("invalid scenario %s: %w", filename, err)
+	}
+	if s.DIDMethod == "" {
+		s.DIDMethod = "sih"
+	}
+	if s.Issuer == "" {
+		s.Issuer = "synthetic"
+	}
+	if s.Window == "" {
+		s.Window = defaultSynthWindow.String()
+	}
+	if s.PingInterval == "" {
+		s.PingInterval = defaultSynthPingInterval.String()
+	}
+	if s.Timeout == "" {
+		s.Timeout = defaultLoadTimeout.String()
+	}
+	if s.Concurrency == 0 {
+		s.Concurrency = defaultSynthConcurrency
+	}
+	for i := range s.Regions {
+		if s.Regions[i].Weight == 0 {
+			s.Regions[i].Weight = 1
+		}
+	}
+	if len(s.Volume.AnomalyTypes) == 0 {
+		s.Volume.AnomalyTypes = synthAnomalyTypes
+	}
+	return &s, s.validate()
+}
+
+func (s *synthScenario) validate() error {
+	if u, err := url.Parse(s.BaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("scenario %s: baseURL must be an http or https URL", s.Name)
+	}
+	if !identifierRegex.MatchString(s.Issuer) || !digitalIDRegex.MatchString("did:"+s.DIDMethod+":x") {
+		return fmt.Errorf("scenario %s: invalid issuer or DID method", s.Name)
+	}
+	if w := s.window(); w < minSynthWindow || w > maxSynthWindow {
+		return fmt.Errorf("scenario %s: window must be between %s and %s", s.Name, minSynthWindow, maxSynthWindow)
+	}
+	if d := s.pingInterval(); d < 10*time.Second || d > time.Hour {
+		return fmt.Errorf("scenario %s: pingInterval must be between 10s and 1h", s.Name)
+	}
+	if timeout, err := time.ParseDuration(s.Timeout); err != nil || timeout <= 0 {
+		return fmt.Errorf("scenario %s: invalid timeout %q", s.Name, s.Timeout)
+	}
+	if s.Concurrency < 1 {
+		return fmt.Errorf("scenario %s: concurrency must be positive", s.Name)
+	}
+
+	if len(s.Regions) == 0 {
+		return fmt.Errorf("scenario %s has no regions", s.Name)
+	}
+	for _, r := range s.Regions {
+		if r.Name == "" || len(r.Name) > maxItineraryStopName {
+			return fmt.Errorf("scenario %s: every region needs a name of at most %d characters", s.Name, maxItineraryStopName)
+		}
+		if r.Latitude < -90 || r.Latitude > 90 || r.Longitude < -180 || r.Longitude > 180 || r.RadiusMeters <= 0 || r.Weight < 0 {
+			return fmt.Errorf("region %s: invalid position, radius or weight", r.Name)
+		}
+		for _, p := range r.Places {
+			if p.Name == "" || len(p.Name) > maxItineraryStopName || p.Latitude < -90 || p.Latitude > 90 || p.Longitude < -180 || p.Longitude > 180 {
+				return fmt.Errorf("region %s: invalid place %q", r.Name, p.Name)
+			}
+		}
+	}
+
+	v := s.Volume
+	if v.Tourists < 1 || v.Tourists > maxSynthTourists {
+		return fmt.Errorf("scenario %s: tourists must be between 1 and %d", s.Name, maxSynthTourists)
+	}
+	if v.IncidentsPerTouristDay < 0 || v.SOSPerTouristDay < 0 || v.AnomalyRate < 0 || v.AnomalyRate > 1 {
+		return fmt.Errorf("scenario %s: rates must not be negative and anomalyRate must be at most 1", s.Name)
+	}
+	for _, kind := range v.AnomalyTypes {
+		if !slices.Contains(synthAnomalyTypes, kind) {
+			return fmt.Errorf("unknown anomaly type %q; expected one of %s", kind, strings.Join(synthAnomalyTypes, ", "))
+		}
+	}
+	return nil
+}
+
+// RunSynthetic is the sih-synth entry point
+func RunSynthetic() {
+	flags := flag.NewFlagSet("sih-synth", flag.ExitOnError)
+	scenarioFile := flags.String("scenario", getEnv("SYNTH_SCENARIO", "scenarios/synthetic-meghalaya.json"), "scenario file describing the regions and volume")
+	baseURL := flags.String("url", getEnv("SYNTH_URL", ""), "gateway URL, overriding the scenario's baseURL")
+	apiKey := flags.String("api-key", getEnv("SYNTH_API_KEY", ""), "API key sent with every request")
+	outDir := flags.String("out", "", "also write the generated data as JSON lines to this directory")
+	offline := flags.Bool("offline", false, "only write the data to -out, without submitting it")
+	runID := flags.String("run", strconv.FormatInt(time.Now().UnixMilli(), 36), "run ID carried by the generated DIDs, incidents and alerts")
+	reportFile := flags.String("report", "", "also write the report as JSON to this file")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: sih-synth [flags]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(os.Args[1:])
+	if flags.NArg() != 0 || (*offline && *outDir == "") || !identifierRegex.MatchString(*runID) {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	scenario, err := readSynthScenario(*scenarioFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *baseURL != "" {
+		scenario.BaseURL = *baseURL
+		if err := scenario.validate(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	tourists := generateSynthetic(scenario, *runID, time.Now().UTC().Truncate(time.Second))
+	log.Printf("🧪 Generated %s", synthSummary(tourists))
+	if *outDir != "" {
+		if err := writeSynthFiles(*outDir, tourists); err != nil {
+			log.Fatalf("❌ Failed to write the generated data: %v", err)
+		}
+		log.Printf("💾 Wrote the generated data to %s", *outDir)
+	}
+	if *offline {
+		return
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	report, err := submitSynthetic(ctx, scenario, *apiKey, *runID, tourists)
+	if err != nil {
+		stop()
+		log.Fatalf("❌ Submission failed: %v", err)
+	}
+	report.write(os.Stdout)
+	if *reportFile != "" {
+		if err := writeJSONFile(*reportFile, report); err != nil {
+			stop()
+			log.Fatalf("❌ Failed to write report: %v", err)
+		}
+	}
+	if len(report.Failures) > 0 {
+		stop()
+		os.Exit(1)
+	}
+}
+
+// synthTourist is one generated tourist. The trace, incidents and alerts are written to files of
+// their own and submitted after the tourist's DID, consent and itinerary.
+type synthTourist struct {
+	DigitalID string           `json:"digitalID"`
+	Region    string           `json:"region"`
+	Itinerary ItineraryRequest `json:"itinerary"`
+	Anomaly   *synthAnomaly    `json:"anomaly,omitempty"`
+	Pings     []synthPing      `json:"-"`
+	Incidents []synthIncident  `json:"-"`
+	SOS       []synthSOS       `json:"-"`
+}
+
+// synthAnomaly labels the anomaly injected into a trace and when it lasted
+type synthAnomaly struct {
+	Type string `json:"type"`
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// synthPing is a generated ping. Anomaly is set on pings inside an injected anomaly, and on the
+// first ping after a signal loss, for training the anomaly models.
+type synthPing struct {
+	DigitalID string `json:"digitalID"`
+	LocationPing
+	Anomaly string `json:"anomaly,omitempty"`
+}
+
+// synthIncident is a generated incident report and its lifecycle. Status changes not yet due by
+// the end of the window are left empty.
+type synthIncident struct {
+	CreateIncidentRequest
+	ReportedAt     string `json:"reportedAt"`
+	AcknowledgedAt string `json:"acknowledgedAt,omitempty"`
+	ResolvedAt     string `json:"resolvedAt,omitempty"`
+}
+
+type synthSOS struct {
+	SOSRequest
+	RaisedAt string `json:"raisedAt"`
+}
+
+// generateSynthetic generates the scenario's tourists with activity over the window ending at
+// now. The same seed, run ID and now give the same data.
+func generateSynthetic(s *synthScenario, runID string, now time.Time) []*synthTourist {
+	tourists := make([]*synthTourist, s.Volume.Tourists)
+	for i := range tourists {
+		tourists[i] = s.tourist(runID, i, now)
+	}
+	return tourists
+}
+
+// synthLeg is part of a tourist's trip: a stay at one stop, or travel from one stop to the next
+type synthLeg struct {
+	from, to         time.Time
+	fromLat, fromLng float64
+	toLat, toLng     float64
+	travel           bool
+}
+
+func (l synthLeg) position(at time.Time) (float64, float64) {
+	if !l.travel {
+		return l.fromLat, l.fromLng
+	}
+	f := float64(at.Sub(l.from)) / float64(l.to.Sub(l.from))
+	f = math.Max(0, math.Min(1, f))
+	return l.fromLat + (l.toLat-l.fromLat)*f, l.fromLng + (l.toLng-l.fromLng)*f
+}
+
+// synthAnomalyWindow is an injected anomaly while the trace is generated
+type synthAnomalyWindow struct {
+	kind     string
+	from, to time.Time
+	bearing  float64
+}
+
+func (a *synthAnomalyWindow) covers(at time.Time) bool {
+	return a != nil && !at.Before(a.from) && !at.After(a.to)
+}
+
+func (s *synthScenario) tourist(runID string, index int, now time.Time) *synthTourist {
+	rng := rand.New(rand.NewPCG(s.Seed, uint64(index)))
+	weights := make([]float64, len(s.Regions))
+	for i, r := range s.Regions {
+		weights[i] = r.Weight
+	}
+	region := s.Regions[weightedIndex(rng, weights)]
+	t := &synthTourist{
+		DigitalID: fmt.Sprintf("did:%s:synth-%s-%d", s.DIDMethod, runID, index),
+		Region:    region.Name,
+	}
+	start := now.Add(-s.window())
+	var legs []synthLeg
+	t.Itinerary, legs = synthItinerary(rng, region, start, now)
+
+	var anomaly *synthAnomalyWindow
+	if rng.Float64() < s.Volume.AnomalyRate {
+		anomaly = synthInjectAnomaly(rng, s.Volume.AnomalyTypes, start, now)
+	}
+	t.Pings = synthTrace(rng, t.DigitalID, legs, anomaly, start, now, s.pingInterval())
+	if anomaly != nil {
+		t.Anomaly = &synthAnomaly{Type: anomaly.kind, From: anomaly.from.Format(time.RFC3339), To: anomaly.to.Format(time.RFC3339)}
+	}
+
+	days := s.window().Hours() / 24
+	for k := range poisson(rng, s.Volume.IncidentsPerTouristDay*days) {
+		if incident, ok := s.incident(rng, t, fmt.Sprintf("SYNTH-INC-%s-%d-%d", runID, index, k), start, now); ok {
+			t.Incidents = append(t.Incidents, incident)
+		}
+	}
+	for k := range poisson(rng, s.Volume.SOSPerTouristDay*days) {
+		at := start.Add(time.Duration(rng.Float64() * float64(now.Sub(start))))
+		ping, ok := pingBefore(t.Pings, at)
+		if !ok {
+			continue
+		}
+		t.SOS = append(t.SOS, synthSOS{
+			SOSRequest: SOSRequest{
+				AlertID:   fmt.Sprintf("SYNTH-SOS-%s-%d-%d", runID, index, k),
+				DigitalID: t.DigitalID,
+				Latitude:  ping.Latitude,
+				Longitude: ping.Longitude,
+				Accuracy:  ping.Accuracy,
+				Message:   "Synthetic SOS",
+				Source:    "app",
+				// The alert is raised after the fact, so there is no countdown left to run
+				Confirmed: true,
+			},
+			RaisedAt: at.Format(time.RFC3339),
+		})
+	}
+	return t
+}
+
+// synthItinerary plans three to six stops between start and end, travelling between them by road,
+// and returns the declared itinerary with the legs the trace follows
+func synthItinerary(rng *rand.Rand, region synthRegion, start, end time.Time) (ItineraryRequest, []synthLeg) {
+	n := 3 + rng.IntN(4)
+	stops := make([]ItineraryStop, n)
+	order := rng.Perm(max(len(region.Places), 1))
+	for k := range stops {
+		var name string
+		var lat, lng float64
+		if len(region.Places) >= 2 {
+			p := region.Places[order[k%len(order)]]
+			name, lat, lng = p.Name, p.Latitude, p.Longitude
+		} else {
+			name = fmt.Sprintf("%s stop %d", region.Name, k+1)
+			lat, lng = offsetPoint(rng, region.Latitude, region.Longitude, region.RadiusMeters)
+		}
+		stops[k] = ItineraryStop{Name: name, Latitude: &lat, Longitude: &lng, RadiusKm: synthStopRadiusKm}
+	}
+
+	// Travel takes at least ten minutes, and at most half the window however far apart the stops are
+	window := end.Sub(start)
+	travel := make([]time.Duration, n-1)
+	var travelling time.Duration
+	for k := range travel {
+		km := haversineKm(*stops[k].Latitude, *stops[k].Longitude, *stops[k+1].Latitude, *stops[k+1].Longitude)
+		travel[k] = max(10*time.Minute, time.Duration(km/synthTravelKmh*float64(time.Hour)))
+		travelling += travel[k]
+	}
+	if travelling > window/2 {
+		for k := range travel {
+			travel[k] = time.Duration(float64(travel[k]) * float64(window/2) / float64(travelling))
+		}
+		travelling = window / 2
+	}
+	stays := make([]float64, n)
+	var total float64
+	for k := range stays {
+		stays[k] = 0.5 + rng.Float64()
+		total += stays[k]
+	}
+
+	var legs []synthLeg
+	at := start
+	for k, stop := range stops {
+		depart := at.Add(time.Duration(stays[k] / total * float64(window-travelling))).Truncate(time.Second)
+		if k == n-1 {
+			depart = end
+		}
+		stops[k].ArriveAt, stops[k].DepartAt = at.Format(time.RFC3339), depart.Format(time.RFC3339)
+		legs = append(legs, synthLeg{from: at, to: depart, fromLat: *stop.Latitude, fromLng: *stop.Longitude})
+		if k < n-1 {
+			arrive := depart.Add(travel[k]).Truncate(time.Second)
+			legs = append(legs, synthLeg{
+				from: depart, to: arrive,
+				fromLat: *stop.Latitude, fromLng: *stop.Longitude,
+				toLat: *stops[k+1].Latitude, toLng: *stops[k+1].Longitude,
+				travel: true,
+			})
+			at = arrive
+		}
+	}
+	return ItineraryRequest{Stops: stops, StartsAt: start.Format(time.RFC3339), EndsAt: end.Format(time.RFC3339)}, legs
+}
+
+// synthInjectAnomaly picks an anomaly and places it away from the edges of the window
+func synthInjectAnomaly(rng *rand.Rand, kinds []string, start, end time.Time) *synthAnomalyWindow {
+	a := &synthAnomalyWindow{kind: kinds[rng.IntN(len(kinds))], bearing: 360 * rng.Float64()}
+	var length time.Duration
+	switch a.kind {
+	case anomalyRouteDeviation:
+		length = synthDeviationFor
+	case anomalyInactivity:
+		length = synthInactivityFor
+	case anomalySignalLoss:
+		length = synthSignalLossFor
+	}
+	window := end.Sub(start)
+	earliest, latest := start.Add(window/10), end.Add(-window/10-length)
+	a.from = earliest.Add(time.Duration(rng.Float64() * float64(latest.Sub(earliest)))).Truncate(time.Second)
+	// A speed jump spans the ping that lands far away and the one after it, known once traced
+	if a.kind != anomalySpeedJump {
+		a.to = a.from.Add(length)
+	}
+	return a
+}
+
+// synthTrace generates the pings of a tourist following legs, at jittered intervals. The tourist
+// wanders around each stay and keeps to the road while travelling.
+func synthTrace(rng *rand.Rand, digitalID string, legs []synthLeg, anomaly *synthAnomalyWindow, start, end time.Time, interval time.Duration) []synthPing {
+	var pings []synthPing
+	var north, east float64
+	battery := 60 + 40*rng.Float64()
+	jumped := false
+	var prev *synthPing
+	var prevAt time.Time
+	at := start.Add(time.Duration(rng.Float64() * float64(interval)))
+	for ; !at.After(end); at = at.Add(time.Duration((0.8 + 0.4*rng.Float64()) * float64(interval))) {
+		at = at.Truncate(time.Second)
+		label := ""
+		if anomaly.covers(at) {
+			label = anomaly.kind
+		}
+		if label == anomalySignalLoss {
+			continue
+		}
+
+		// An inactive tourist holds still, then carries on with the rest of the trip late
+		clock := at
+		holding := false
+		if anomaly != nil && anomaly.kind == anomalyInactivity && !at.Before(anomaly.from) {
+			clock = anomaly.from
+			if late := at.Add(-synthInactivityFor); late.After(clock) {
+				clock = late
+			}
+			holding = label != ""
+		}
+		leg := legs[len(legs)-1]
+		for _, l := range legs {
+			if clock.Before(l.to) {
+				leg = l
+				break
+			}
+		}
+		lat, lng := leg.position(clock)
+
+		switch {
+		case holding:
+		case leg.travel:
+			north, east = north/2, east/2
+		default:
+			north += synthWanderMeters * rng.NormFloat64()
+			east += synthWanderMeters * rng.NormFloat64()
+			if d := math.Hypot(north, east); d > synthStayMeters {
+				north, east = north*synthStayMeters/d, east*synthStayMeters/d
+			}
+		}
+		if d := math.Hypot(north, east); d > 0 {
+			lat, lng = destinationPoint(lat, lng, math.Atan2(east, north)*180/math.Pi, d/1000)
+		}
+		if label == anomalyRouteDeviation {
+			f := math.Sin(math.Pi * float64(at.Sub(anomaly.from)) / float64(anomaly.to.Sub(anomaly.from)))
+			lat, lng = destinationPoint(lat, lng, anomaly.bearing, synthDeviationMeters*f/1000)
+		}
+		if anomaly != nil && anomaly.kind == anomalySpeedJump && !jumped && !at.Before(anomaly.from) {
+			// One ping lands far away, as a spoofed or corrupted fix does, and the next is back on track
+			lat, lng = destinationPoint(lat, lng, anomaly.bearing, synthJumpMeters/1000)
+			anomaly.from, jumped = at, true
+			label = anomaly.kind
+		} else if jumped && anomaly.to.IsZero() {
+			anomaly.to = at
+			label = anomaly.kind
+		}
+		if prev != nil && prev.Anomaly == "" && anomaly != nil && anomaly.kind == anomalySignalLoss && prevAt.Before(anomaly.from) && at.After(anomaly.to) {
+			label = anomalySignalLoss
+		}
+
+		accuracy := 5 + 20*rng.Float64()
+		lat, lng = offsetPoint(rng, lat, lng, accuracy/2)
+		ping := synthPing{DigitalID: digitalID, Anomaly: label, LocationPing: LocationPing{
+			Latitude:   &lat,
+			Longitude:  &lng,
+			Accuracy:   math.Round(accuracy*10) / 10,
+			RecordedAt: at.Format(time.RFC3339),
+		}}
+		if prev != nil {
+			elapsed := at.Sub(prevAt)
+			speed := math.Round(haversineKm(*prev.Latitude, *prev.Longitude, lat, lng)*1e6/elapsed.Seconds()) / 1000
+			heading := math.Round(math.Mod(initialBearing(*prev.Latitude, *prev.Longitude, lat, lng)+360, 360)*10) / 10
+			ping.Speed, ping.Heading = &speed, &heading
+			if heading >= 360 {
+				*ping.Heading = 0
+			}
+			battery -= elapsed.Minutes() / 12
+			if battery < 15 && !leg.travel {
+				battery = 100
+			}
+		}
+		level := max(int(battery), 0)
+		ping.Battery = &level
+		pings = append(pings, ping)
+		prev, prevAt = &pings[len(pings)-1], at
+	}
+	return pings
+}
+
+// incident reports something happening to the tourist at a random time, where they were then. It
+// is acknowledged within minutes and resolved within hours, as far as the window allows.
+func (s *synthScenario) incident(rng *rand.Rand, t *synthTourist, incidentID string, start, now time.Time) (synthIncident, bool) {
+	at := start.Add(time.Duration(rng.Float64() * float64(now.Sub(start)))).Truncate(time.Second)
+	ping, ok := pingBefore(t.Pings, at)
+	if !ok {
+		return synthIncident{}, false
+	}
+	category := synthIncidentCategories[rng.IntN(len(synthIncidentCategories))]
+	summary := sha256.Sum256([]byte(fmt.Sprintf("Synthetic %s report %s about %s at %s", category, incidentID, t.DigitalID, at.Format(time.RFC3339))))
+	incident := synthIncident{
+		CreateIncidentRequest: CreateIncidentRequest{
+			IncidentID:          incidentID,
+			IncidentSummaryHash: hex.EncodeToString(summary[:]),
+			Reporter:            s.Issuer,
+			Severity:            incidentSeverities[weightedIndex(rng, synthSeverityWeights)],
+			Category:            category,
+			DigitalID:           t.DigitalID,
+			Latitude:            ping.Latitude,
+			Longitude:           ping.Longitude,
+		},
+		ReportedAt: at.Format(time.RFC3339),
+	}
+	acknowledged := at.Add(time.Duration(rng.ExpFloat64() * float64(10*time.Minute))).Truncate(time.Second)
+	resolved := acknowledged.Add(time.Duration(rng.ExpFloat64() * float64(3*time.Hour))).Truncate(time.Second)
+	if acknowledged.Before(now) {
+		incident.AcknowledgedAt = acknowledged.Format(time.RFC3339)
+	}
+	if resolved.Before(now) {
+		incident.ResolvedAt = resolved.Format(time.RFC3339)
+	}
+	return incident, true
+}
+
+// pingBefore returns the last ping recorded at or before at, or the first ping when there is none
+func pingBefore(pings []synthPing, at time.Time) (LocationPing, bool) {
+	if len(pings) == 0 {
+		return LocationPing{}, false
+	}
+	stamp := at.UTC().Format(time.RFC3339)
+	i, _ := slices.BinarySearchFunc(pings, stamp, func(p synthPing, stamp string) int { return strings.Compare(p.RecordedAt, stamp) })
+	if i < len(pings) && pings[i].RecordedAt == stamp {
+		return pings[i].LocationPing, true
+	}
+	return pings[max(i-1, 0)].LocationPing, true
+}
+
+// weightedIndex picks an index with probability proportional to its weight
+func weightedIndex(rng *rand.Rand, weights []float64) int {
+	var total float64
+	for _, w := range weights {
+		total += w
+	}
+	pick := rng.Float64() * total
+	for i, w := range weights {
+		if pick < w {
+			return i
+		}
+		pick -= w
+	}
+	return len(weights) - 1
+}
+
+// poisson draws from a Poisson distribution with the given mean by counting exponential arrivals
+func poisson(rng *rand.Rand, mean float64) int {
+	n := 0
+	for t := rng.ExpFloat64(); t < mean; t += rng.ExpFloat64() {
+		n++
+	}
+	return n
+}
+
+func synthSummary(tourists []*synthTourist) string {
+	var pings, incidents, alerts, anomalies int
+	for _, t := range tourists {
+		pings += len(t.Pings)
+		incidents += len(t.Incidents)
+		alerts += len(t.SOS)
+		if t.Anomaly != nil {
+			anomalies++
+		}
+	}
+	return fmt.Sprintf("%d tourists, %d pings, %d incidents, %d SOS alerts and %d anomalies", len(tourists), pings, incidents, alerts, anomalies)
+}
+
+// writeSynthFiles writes tourists.jsonl with each tourist's itinerary and anomaly label, and
+// pings.jsonl, incidents.jsonl and sos.jsonl with their activity, one JSON object per line
+func writeSynthFiles(dir string, tourists []*synthTourist) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	files := []struct {
+		name  string
+		items func(t *synthTourist) []interface{}
+	}{
+		{"tourists.jsonl", func(t *synthTourist) []interface{} { return []interface{}{t} }},
+		{"pings.jsonl", func(t *synthTourist) []interface{} { return synthItems(t.Pings) }},
+		{"incidents.jsonl", func(t *synthTourist) []interface{} { return synthItems(t.Incidents) }},
+		{"sos.jsonl", func(t *synthTourist) []interface{} { return synthItems(t.SOS) }},
+	}
+	for _, file := range files {
+		f, err := os.Create(filepath.Join(dir, file.name))
+		if err != nil {
+			return err
+		}
+		w := bufio.NewWriter(f)
+		encoder := json.NewEncoder(w)
+		for _, t := range tourists {
+			for _, item := range file.items(t) {
+				if err := encoder.Encode(item); err != nil {
+					f.Close()
+					return err
+				}
+			}
+		}
+		if err := w.Flush(); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func synthItems[T any](items []T) []interface{} {
+	out := make([]interface{}, len(items))
+	for i := range items {
+		out[i] = items[i]
+	}
+	return out
+}
+
+// submitSynthetic sends the generated data through the gateway: each tourist's DID, location
+// consent and itinerary first, then their pings, incidents with their status changes, and SOS
+// alerts. Failed requests are counted, and a tourist whose setup failed is skipped.
+func submitSynthetic(ctx context.Context, s *synthScenario, apiKey, runID string, tourists []*synthTourist) (*loadReport, error) {
+	timeout, _ := time.ParseDuration(s.Timeout)
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = s.Concurrency
+	r := &loadRunner{
+		client:  &http.Client{Timeout: timeout, Transport: transport},
+		baseURL: strings.TrimSuffix(s.BaseURL, "/") + "/api/v1",
+		apiKey:  apiKey,
+		runID:   runID,
+	}
+	report := &loadReport{Scenario: s.Name, RunID: runID, StartedAt: time.Now().UTC().Format(time.RFC3339)}
+
+	setup := newLoadStats()
+	started := time.Now()
+	log.Printf("🧳 Registering %d synthetic tourists", len(tourists))
+	ready := make([]bool, len(tourists))
+	synthEach(ctx, len(tourists), s.Concurrency, func(i int) {
+		ready[i] = s.register(ctx, r, setup, tourists[i])
+	})
+	report.Setup = setup.report(time.Since(started))
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	for _, ok := range ready {
+		if ok {
+			report.Tourists++
+		}
+	}
+	if report.Tourists == 0 {
+		return nil, fmt.Errorf("no synthetic tourists could be registered; check the URL and API key")
+	}
+
+	stats := newLoadStats()
+	started = time.Now()
+	log.Printf("📡 Submitting the activity of %d tourists", report.Tourists)
+	synthEach(ctx, len(tourists), s.Concurrency, func(i int) {
+		if ready[i] {
+			s.submit(ctx, r, stats, tourists[i])
+		}
+	})
+	report.Run = stats.report(time.Since(started))
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	for _, phase := range []loadPhaseReport{report.Setup, report.Run} {
+		for _, o := range phase.Operations {
+			if o.Errors > 0 {
+				report.Failures = append(report.Failures, fmt.Sprintf("%s: %d of %d requests failed", o.Operation, o.Errors, o.Requests))
+			}
+		}
+	}
+	return report, nil
+}
+
+// synthEach calls fn for every index below n from concurrency workers, stopping early when ctx ends
+func synthEach(ctx context.Context, n, concurrency int, fn func(i int)) {
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range min(concurrency, n) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < n && ctx.Err() == nil; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+func (s *synthScenario) register(ctx context.Context, r *loadRunner, stats *loadStats, t *synthTourist) bool {
+	id := url.PathEscape(t.DigitalID)
+	expiresAt := time.Now().Add(synthDIDValidity).UTC().Format(time.RFC3339)
+	consentHash := sha256.Sum256([]byte(t.DigitalID))
+	if status := r.call(ctx, stats, synthOpDID, http.MethodPost, "/did/", CreateDIDRequest{
+		DigitalID:   t.DigitalID,
+		ConsentHash: hex.EncodeToString(consentHash[:]),
+		ExpiresAt:   expiresAt,
+		Issuer:      s.Issuer,
+	}); status/100 != 2 {
+		return false
+	}
+	if status := r.call(ctx, stats, synthOpConsent, http.MethodPost, "/consents/"+id+"/grant", GrantConsentRequest{
+		Purpose:       purposeLocationTracking,
+		PolicyVersion: "synthetic",
+		ExpiresAt:     expiresAt,
+		Actor:         s.Issuer,
+	}); status/100 != 2 {
+		return false
+	}
+	return r.call(ctx, stats, synthOpItinerary, http.MethodPut, "/itinerary/"+id, t.Itinerary)/100 == 2
+}
+
+func (s *synthScenario) submit(ctx context.Context, r *loadRunner, stats *loadStats, t *synthTourist) {
+	for batch := range slices.Chunk(t.Pings, maxLocationBatch) {
+		pings := make([]LocationPing, len(batch))
+		for i, p := range batch {
+			pings[i] = p.LocationPing
+		}
+		r.call(ctx, stats, synthOpPings, http.MethodPost, "/location/pings", LocationPingsRequest{DigitalID: t.DigitalID, Pings: pings})
+	}
+	for _, incident := range t.Incidents {
+		if r.call(ctx, stats, synthOpIncident, http.MethodPost, "/incident/", incident.CreateIncidentRequest)/100 != 2 {
+			continue
+		}
+		path := "/incident/" + url.PathEscape(incident.IncidentID) + "/status"
+		for _, change := range []struct{ status, at string }{{"acknowledged", incident.AcknowledgedAt}, {"resolved", incident.ResolvedAt}} {
+			if change.at == "" {
+				break
+			}
+			if r.call(ctx, stats, synthOpIncidentStatus, http.MethodPut, path, UpdateIncidentStatusRequest{Status: change.status, Updater: s.Issuer})/100 != 2 {
+				break
+			}
+		}
+	}
+	for _, alert := range t.SOS {
+		r.call(ctx, stats, synthOpSOS, http.MethodPost, "/sos", alert.SOSRequest)
+	}
+}
@@ -0,0 +1,144 @@
+package gateway
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testSynthScenario(t *testing.T) *synthScenario {
+	s, err := readSynthScenario("scenarios/synthetic-meghalaya.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Volume = synthVolume{Tourists: 20, IncidentsPerTouristDay: 1, SOSPerTouristDay: 1, AnomalyRate: 1, AnomalyTypes: synthAnomalyTypes}
+	return s
+}
+
+func TestGenerateSynthetic(t *testing.T) {
+	s := testSynthScenario(t)
+	now := time.Now().UTC().Truncate(time.Second)
+	tourists := generateSynthetic(s, "test", now)
+	if !reflect.DeepEqual(tourists, generateSynthetic(s, "test", now)) {
+		t.Fatal("expected the same seed to generate the same data")
+	}
+
+	var incidents, alerts int
+	for _, tourist := range tourists {
+		if !strings.HasPrefix(tourist.DigitalID, "did:sih:synth-test-") || tourist.Anomaly == nil {
+			t.Fatalf("expected a run DID with an anomaly, got %+v", tourist)
+		}
+		if errs := tourist.Itinerary.Validate(); len(errs) > 0 {
+			t.Fatalf("expected a valid itinerary for %s, got %v", tourist.DigitalID, errs)
+		}
+		if !slices.IsSortedFunc(tourist.Pings, func(a, b synthPing) int { return strings.Compare(a.RecordedAt, b.RecordedAt) }) {
+			t.Fatalf("expected the pings of %s in order", tourist.DigitalID)
+		}
+		for batch := range slices.Chunk(tourist.Pings, maxLocationBatch) {
+			req := LocationPingsRequest{DigitalID: tourist.DigitalID}
+			for _, p := range batch {
+				req.Pings = append(req.Pings, p.LocationPing)
+			}
+			if errs := req.Validate(); len(errs) > 0 {
+				t.Fatalf("expected the gateway to accept the pings of %s, got %v", tourist.DigitalID, errs)
+			}
+		}
+		for _, incident := range tourist.Incidents {
+			if errs := incident.Validate(); len(errs) > 0 || incident.Category == "anomaly" {
+				t.Fatalf("expected a valid reported incident, got %+v, %v", incident, errs)
+			}
+			if incident.ResolvedAt != "" && (incident.AcknowledgedAt == "" || incident.ResolvedAt < incident.AcknowledgedAt) {
+				t.Errorf("expected %s acknowledged before it was resolved", incident.IncidentID)
+			}
+		}
+		for _, alert := range tourist.SOS {
+			if !alert.Confirmed || alert.Latitude == nil {
+				t.Errorf("expected a confirmed, located alert, got %+v", alert)
+			}
+		}
+		incidents += len(tourist.Incidents)
+		alerts += len(tourist.SOS)
+	}
+	if incidents == 0 || alerts == 0 {
+		t.Errorf("expected incidents and alerts at a rate of one per tourist-day, got %d and %d", incidents, alerts)
+	}
+}
+
+func TestSynthAnomalies(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	for _, kind := range synthAnomalyTypes {
+		t.Run(kind, func(t *testing.T) {
+			s := testSynthScenario(t)
+			s.Volume.AnomalyTypes = []string{kind}
+			for _, tourist := range generateSynthetic(s, "test", now) {
+				var labeled []int
+				for i, p := range tourist.Pings {
+					if p.Anomaly != "" {
+						labeled = append(labeled, i)
+					}
+				}
+				if tourist.Anomaly.Type != kind || len(labeled) == 0 {
+					t.Fatalf("expected labeled %s pings, got %+v", kind, tourist.Anomaly)
+				}
+				first, last := tourist.Pings[labeled[0]], tourist.Pings[labeled[len(labeled)-1]]
+				span := func(a, b synthPing) time.Duration {
+					from, _ := time.Parse(time.RFC3339, a.RecordedAt)
+					to, _ := time.Parse(time.RFC3339, b.RecordedAt)
+					return to.Sub(from)
+				}
+
+				switch kind {
+				case anomalyRouteDeviation:
+					if span(first, last) < time.Hour {
+						t.Errorf("expected a deviation lasting over an hour, got %s", span(first, last))
+					}
+				case anomalyInactivity:
+					for _, i := range labeled {
+						p := tourist.Pings[i]
+						if km := haversineKm(*first.Latitude, *first.Longitude, *p.Latitude, *p.Longitude); km > 0.1 {
+							t.Fatalf("expected the tourist still, moved %.3fkm", km)
+						}
+					}
+					if span(first, last) < 2*time.Hour {
+						t.Errorf("expected inactivity lasting over two hours, got %s", span(first, last))
+					}
+				case anomalySignalLoss:
+					i := labeled[0]
+					if len(labeled) != 1 || i == 0 || span(tourist.Pings[i-1], first) < synthSignalLossFor {
+						t.Errorf("expected one labeled ping after a gap, got %v", labeled)
+					}
+				case anomalySpeedJump:
+					if len(labeled) != 2 || *first.Speed*3.6 < 250 || *last.Speed*3.6 < 250 {
+						t.Errorf("expected a jump away and back above 250km/h, got %v", labeled)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestSynthScenarioValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(s *synthScenario)
+		want   string
+	}{
+		{"window", func(s *synthScenario) { s.Window = "72h" }, "window must be between"},
+		{"region", func(s *synthScenario) { s.Regions[0].RadiusMeters = 0 }, "region Shillong"},
+		{"place", func(s *synthScenario) { s.Regions[1].Places[0].Latitude = 91 }, "invalid place"},
+		{"tourists", func(s *synthScenario) { s.Volume.Tourists = 0 }, "tourists must be between"},
+		{"rate", func(s *synthScenario) { s.Volume.AnomalyRate = 1.5 }, "anomalyRate"},
+		{"anomaly", func(s *synthScenario) { s.Volume.AnomalyTypes = []string{"teleport"} }, "unknown anomaly type"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := testSynthScenario(t)
+			tt.change(s)
+			if err := s.validate(); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// targetHeader selects the channel and chaincode a request is routed to
+const targetHeader = "X-Fabric-Target"
+
+// fabricTarget is a named channel and chaincode pair the gateway can route requests to
+type fabricTarget struct {
+	Name      string `json:"name"`
+	Channel   string `json:"channel"`
+	Chaincode string `json:"chaincode"`
+
+	network  *client.Network
+	contract *client.Contract
+}
+
+// TargetRegistry lists the configured targets and the one used when a request names none
+type TargetRegistry struct {
+	Default string          `json:"default"`
+	Targets []*fabricTarget `json:"targets"`
+}
+
+var (
+	fabricTargets map[string]*fabricTarget
+	defaultTarget *fabricTarget
+)
+
+type targetContextKey struct{}
+
+// parseFabricTargets reads a registry of the form "name=channel/chaincode,..." and the name of the
+// target used when a request does not choose one. An empty registry serves only the built-in target.
+func parseFabricTargets(raw, defaultName string) (map[string]*fabricTarget, *fabricTarget, error) {
+	registry := map[string]*fabricTarget{}
+	var first string
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, location, ok := strings.Cut(entry, "=")
+		channel, chaincode, hasChaincode := strings.Cut(location, "/")
+		name, channel, chaincode = strings.TrimSpace(name), strings.TrimSpace(channel), strings.TrimSpace(chaincode)
+		if !ok || !hasChaincode || name == "" || channel == "" || chaincode == "" {
+			return nil, nil, fmt.Errorf("invalid target %q, expected name=channel/chaincode", entry)
+		}
+		if _, exists := registry[name]; exists {
+			return nil, nil, fmt.Errorf("target %q is listed more than once", name)
+		}
+		registry[name] = &fabricTarget{Name: name, Channel: channel, Chaincode: chaincode}
+		if first == "" {
+			first = name
+		}
+	}
+
+	if len(registry) == 0 {
+		registry["default"] = &fabricTarget{Name: "default", Channel: defaultChannelName, Chaincode: defaultChaincodeName}
+		first = "default"
+	}
+	if defaultName == "" {
+		defaultName = first
+	}
+	target, ok := registry[defaultName]
+	if !ok {
+		return nil, nil, fmt.Errorf("default target %q is not in the registry", defaultName)
+	}
+	return registry, target, nil
+}
+
+// initFabricTargets binds each configured target to its network and contract on the gateway
+func initFabricTargets() {
+	registry, fallback, err := parseFabricTargets(getEnv("FABRIC_TARGETS", ""), getEnv("FABRIC_DEFAULT_TARGET", ""))
+	if err != nil {
+		panic(fmt.Errorf("failed to configure fabric targets: %w", err))
+	}
+	for _, target := range registry {
+		target.network = gateway.GetNetwork(target.Channel)
+		target.contract = target.network.GetContract(target.Chaincode)
+	}
+	fabricTargets, defaultTarget = registry, fallback
+}
+
+// networkFor returns the target's network as seen by the signing identity chosen for ctx
+func (t *fabricTarget) networkFor(ctx context.Context) *client.Network {
+	if gw := gatewayFromContext(ctx); gw != gateway {
+		return gw.GetNetwork(t.Channel)
+	}
+	return t.network
+}
+
+// contractFor returns the target's contract as seen by the signing identity chosen for ctx
+func (t *fabricTarget) contractFor(ctx context.Context) *client.Contract {
+	if gw := gatewayFromContext(ctx); gw != gateway {
+		return gw.GetNetwork(t.Channel).GetContract(t.Chaincode)
+	}
+	return t.contract
+}
+
+// sortedTargets lists the registry in name order
+func sortedTargets() []*fabricTarget {
+	list := make([]*fabricTarget, 0, len(fabricTargets))
+	for _, target := range fabricTargets {
+		list = append(list, target)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// withTarget routes Fabric calls made with the returned context to target
+func withTarget(ctx context.Context, target *fabricTarget) context.Context {
+	return context.WithValue(ctx, targetContextKey{}, target)
+}
+
+// targetFromContext returns the target chosen for the request, or the default target
+func targetFromContext(ctx context.Context) *fabricTarget {
+	if target, ok := ctx.Value(targetContextKey{}).(*fabricTarget); ok {
+		return target
+	}
+	return defaultTarget
+}
+
+// isDefaultTarget reports whether ctx is routed to the default target. State kept outside the ledger,
+// such as the off-chain index, only covers the default target.
+func isDefaultTarget(ctx context.Context) bool {
+	return targetFromContext(ctx) == defaultTarget
+}
+
+// lookupTarget resolves a target name, treating an empty name as the default target
+func lookupTarget(name string) (*fabricTarget, error) {
+	if name == "" {
+		return defaultTarget, nil
+	}
+	if target, ok := fabricTargets[name]; ok {
+		return target, nil
+	}
+	return nil, fmt.Errorf("unknown fabric target %q", name)
+}
+
+// targetMiddleware routes the request to the target named in the X-Fabric-Target header
+func targetMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		target, err := lookupTarget(c.GetHeader(targetHeader))
+		if err != nil {
+			respondValidationErrors(c, ValidationErrors{{Field: targetHeader, Message: err.Error()}})
+			c.Abort()
+			return
+		}
+		c.Header(targetHeader, target.Name)
+		c.Request = c.Request.WithContext(withTarget(c.Request.Context(), target))
+		c.Next()
+	}
+}
+
+// grpcTargetInterceptor routes an RPC to the target named in the x-fabric-target metadata
+func grpcTargetInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := grpcTargetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// grpcTargetStreamInterceptor routes a stream in the same way
+func grpcTargetStreamInterceptor(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := grpcTargetContext(stream.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srv, contextStream{stream, ctx})
+}
+
+func grpcTargetContext(ctx context.Context) (context.Context, error) {
+	var name string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(targetHeader); len(values) > 0 {
+			name = values[0]
+		}
+	}
+	target, err := lookupTarget(name)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return withTarget(ctx, target), nil
+}
+
+func listTargets(c *gin.Context) {
+	respondData(c, http.StatusOK, TargetRegistry{Default: defaultTarget.Name, Targets: sortedTargets()})
+}
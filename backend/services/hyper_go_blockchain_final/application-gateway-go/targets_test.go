@@ -0,0 +1,72 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseFabricTargets(t *testing.T) {
+	registry, fallback, err := parseFabricTargets("identity=identity-channel/sihcc, incident = incident-channel/incidentcc", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(registry) != 2 || fallback.Name != "identity" {
+		t.Fatalf("unexpected registry %v with default %+v", registry, fallback)
+	}
+	if incident := registry["incident"]; incident.Channel != "incident-channel" || incident.Chaincode != "incidentcc" {
+		t.Errorf("unexpected incident target %+v", incident)
+	}
+
+	registry, fallback, err = parseFabricTargets("", "")
+	if err != nil || len(registry) != 1 || fallback.Channel != defaultChannelName || fallback.Chaincode != defaultChaincodeName {
+		t.Errorf("expected the built-in target, got %v %+v (%v)", registry, fallback, err)
+	}
+
+	for _, bad := range []struct{ raw, defaultName string }{
+		{"identity=identity-channel", ""},
+		{"=identity-channel/sihcc", ""},
+		{"a=c1/cc,a=c2/cc", ""},
+		{"a=c1/cc", "b"},
+	} {
+		if _, _, err := parseFabricTargets(bad.raw, bad.defaultName); err == nil {
+			t.Errorf("expected %q (default %q) to be rejected", bad.raw, bad.defaultName)
+		}
+	}
+}
+
+func TestTargetMiddleware(t *testing.T) {
+	identity := &fabricTarget{Name: "identity", Channel: "identity-channel", Chaincode: "sihcc"}
+	incident := &fabricTarget{Name: "incident", Channel: "incident-channel", Chaincode: "sihcc"}
+	previousTargets, previousDefault := fabricTargets, defaultTarget
+	fabricTargets, defaultTarget = map[string]*fabricTarget{"identity": identity, "incident": incident}, identity
+	t.Cleanup(func() { fabricTargets, defaultTarget = previousTargets, previousDefault })
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/target", targetMiddleware(), func(c *gin.Context) {
+		c.String(http.StatusOK, targetFromContext(c.Request.Context()).Channel)
+	})
+
+	for header, want := range map[string]string{"": "identity-channel", "incident": "incident-channel"} {
+		req := httptest.NewRequest(http.MethodGet, "/target", nil)
+		if header != "" {
+			req.Header.Set(targetHeader, header)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusOK || w.Body.String() != want {
+			t.Errorf("header %q: got %d %q, want %q", header, w.Code, w.Body.String(), want)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/target", nil)
+	req.Header.Set(targetHeader, "unknown")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected an unknown target to be rejected, got %d", w.Code)
+	}
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// tenancyConfig scopes reads to the caller's organization when several police districts and
+// tourism bodies share one gateway
+type tenancyConfig struct {
+	enabled bool
+	// adminOrgs may see every organization's records, such as a state control room
+	adminOrgs map[string]bool
+	// legacyOrg owns records written before the chaincode recorded an owner
+	legacyOrg string
+}
+
+var tenancy tenancyConfig
+
+// initTenancy enables organization scoping when TENANCY_ENABLED is set. Runs after initWallet.
+func initTenancy() {
+	if !getEnvBool("TENANCY_ENABLED", false) {
+		log.Println("🏢 TENANCY_ENABLED not set, every caller sees every organization's records")
+		return
+	}
+
+	tenancy = tenancyConfig{enabled: true, adminOrgs: map[string]bool{}, legacyOrg: getEnv("TENANCY_LEGACY_ORG", mspID)}
+	for _, org := range strings.Split(getEnv("TENANCY_ADMIN_ORGS", ""), ",") {
+		if org = strings.TrimSpace(org); org != "" {
+			tenancy.adminOrgs[org] = true
+		}
+	}
+	if callerWallet == nil {
+		log.Printf("🏢 Tenancy enabled without WALLET_PATH; every caller acts for %s", mspID)
+	}
+	log.Printf("🏢 Tenancy enabled, unowned records belong to %s, %d admin organizations", tenancy.legacyOrg, len(tenancy.adminOrgs))
+}
+
+// tenant is the organization a request reads on behalf of
+type tenant struct {
+	org string
+	all bool
+}
+
+// tenantFromContext derives the tenant from the identity the request signs as, so REST, GraphQL and
+// gRPC callers are scoped by the same credential that endorses their transactions. Admin
+// organizations and roles granted "tenancy, all" see every organization's records.
+func tenantFromContext(ctx context.Context) tenant {
+	if !tenancy.enabled {
+		return tenant{all: true}
+	}
+	org := callerOrg(ctx)
+	return tenant{org: org, all: tenancy.adminOrgs[org] || (access != nil && access.allows(ctx, "tenancy", "all"))}
+}
+
+// callerOrg returns the organization the request signs as
+func callerOrg(ctx context.Context) string {
+	if id, ok := ctx.Value(signerContextKey{}).(*walletIdentity); ok && id != nil {
+		return id.MSPID
+	}
+	return mspID
+}
+
+// owns reports whether a record owned by ownerOrg is visible to the tenant
+func (t tenant) owns(ownerOrg string) bool {
+	if ownerOrg == "" {
+		ownerOrg = tenancy.legacyOrg
+	}
+	return t.all || ownerOrg == t.org
+}
+
+// auditOwner returns the organization that submitted an audited transaction; submitters are
+// "<mspid>/<common name>"
+func auditOwner(audit AuditDocument) string {
+	org, _, _ := strings.Cut(audit.Submitter, "/")
+	return org
+}
+
+// includesUnowned reports whether the tenant also sees records without an owner
+func (t tenant) includesUnowned() bool {
+	return t.org == tenancy.legacyOrg
+}
+
+// restrict adds the tenant's ownership condition on column to an index query; the index stores
+// unowned records with an empty owner
+func (t tenant) restrict(f *queryFilter, column string) {
+	switch {
+	case t.all:
+	case t.includesUnowned():
+		f.add(fmt.Sprintf("(%s = ? OR %s = '')", column, column), t.org)
+	default:
+		f.add(column+" = ?", t.org)
+	}
+}
+
+// filterOwned drops the records the tenant does not own
+func filterOwned[T any](t tenant, docs []T, owner func(T) string) []T {
+	if t.all {
+		return docs
+	}
+	owned := make([]T, 0, len(docs))
+	for _, doc := range docs {
+		if t.owns(owner(doc)) {
+			owned = append(owned, doc)
+		}
+	}
+	return owned
+}
@@ -0,0 +1,74 @@
+package gateway
+
+import (
+	"context"
+	"testing"
+)
+
+func TestTenancyScoping(t *testing.T) {
+	ctx := context.Background()
+	if tenant := tenantFromContext(ctx); !tenant.all {
+		t.Fatalf("expected every record visible with tenancy disabled, got %+v", tenant)
+	}
+
+	tenancy = tenancyConfig{enabled: true, adminOrgs: map[string]bool{"StateMSP": true}, legacyOrg: "Org1MSP"}
+	defer func() { tenancy = tenancyConfig{} }()
+
+	org1 := tenantFromContext(ctx)
+	district := tenantFromContext(withSigner(ctx, &walletIdentity{Label: "shillong", MSPID: "Org2MSP"}))
+	state := tenantFromContext(withSigner(ctx, &walletIdentity{Label: "control-room", MSPID: "StateMSP"}))
+	if org1.org != "Org1MSP" || district.org != "Org2MSP" || !state.all || district.all {
+		t.Fatalf("unexpected tenants %+v %+v %+v", org1, district, state)
+	}
+
+	// Records written before owners were recorded belong to the legacy organization
+	incidents := []IncidentDocument{{IncidentID: "legacy"}, {IncidentID: "org1", OwnerOrg: "Org1MSP"}, {IncidentID: "org2", OwnerOrg: "Org2MSP"}}
+	owner := func(i IncidentDocument) string { return i.OwnerOrg }
+	for _, tc := range []struct {
+		tenant tenant
+		want   []string
+	}{
+		{org1, []string{"legacy", "org1"}},
+		{district, []string{"org2"}},
+		{state, []string{"legacy", "org1", "org2"}},
+	} {
+		got := filterOwned(tc.tenant, incidents, owner)
+		if len(got) != len(tc.want) {
+			t.Fatalf("%s: expected %v, got %+v", tc.tenant.org, tc.want, got)
+		}
+		for i := range got {
+			if got[i].IncidentID != tc.want[i] {
+				t.Errorf("%s: expected %v, got %+v", tc.tenant.org, tc.want, got)
+			}
+		}
+	}
+
+	audits := []AuditDocument{{Submitter: "Org2MSP/officer1"}, {Submitter: "Org2MSPX/officer2"}, {}}
+	if got := filterOwned(district, audits, auditOwner); len(got) != 1 || got[0].Submitter != "Org2MSP/officer1" {
+		t.Errorf("expected only the district's own audit entry, got %+v", got)
+	}
+
+	for _, tc := range []struct {
+		tenant tenant
+		where  string
+		args   int
+	}{
+		{org1, "(owner_org = $1 OR owner_org = '')", 1},
+		{district, "owner_org = $1", 1},
+		{state, "TRUE", 0},
+	} {
+		var f queryFilter
+		tc.tenant.restrict(&f, "owner_org")
+		if f.where() != tc.where || len(f.args) != tc.args {
+			t.Errorf("%+v: expected %q, got %q with %v", tc.tenant, tc.where, f.where(), f.args)
+		}
+	}
+
+	devices := map[string]PushDevice{"legacy": {}, "district": {Org: "Org2MSP"}, "state": {Org: "StateMSP"}}
+	for name, want := range map[string][]bool{"legacy": {true, false}, "district": {false, true}, "state": {true, true}} {
+		device := devices[name]
+		if got := []bool{device.receives(""), device.receives("Org2MSP")}; got[0] != want[0] || got[1] != want[1] {
+			t.Errorf("%s device: expected unowned/district alerts %v, got %v", name, want, got)
+		}
+	}
+}
@@ -0,0 +1,591 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Timeline entry sources
+const (
+	timelineLedger       = "ledger"
+	timelineAudit        = "audit"
+	timelineEvidence     = "evidence"
+	timelineLocation     = "location"
+	timelineDispatch     = "dispatch"
+	timelineNotification = "notification"
+
+	// timelineLocationLead is how long before an incident was reported the subject's pings are
+	// included, to show how they came to be where it happened
+	timelineLocationLead = 2 * time.Hour
+)
+
+var timelineSources = []string{timelineLedger, timelineAudit, timelineEvidence, timelineLocation, timelineDispatch, timelineNotification}
+
+// IncidentTimelineRequest limits a timeline to some of its sources; by default it has all of them
+type IncidentTimelineRequest struct {
+	Sources []string `form:"source"`
+}
+
+func (r IncidentTimelineRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	for i, source := range r.Sources {
+		v.oneOf(fmt.Sprintf("source[%d]", i), source, timelineSources)
+	}
+	return v.errors
+}
+
+func (r IncidentTimelineRequest) includes(source string) bool {
+	return len(r.Sources) == 0 || slices.Contains(r.Sources, source)
+}
+
+// TimelineAnnexureRequest renders an incident's timeline as an annexure for a court filing
+type TimelineAnnexureRequest struct {
+	GeneratedBy string `json:"generatedBy" binding:"required"`
+	// CaseReference is the court or FIR reference the annexure is filed under, printed on it
+	CaseReference string `json:"caseReference"`
+}
+
+func (r TimelineAnnexureRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.identifier("generatedBy", r.GeneratedBy)
+	if len(r.CaseReference) > maxClaimNumberChars {
+		v.add("caseReference", "must be at most %d characters", maxClaimNumberChars)
+	}
+	return v.errors
+}
+
+// TimelineEntry is one thing that happened to an incident. TxID is the transaction that last wrote
+// the ledger record the entry comes from; Anchored is false for entries only the gateway holds,
+// such as raw pings and notification deliveries.
+type TimelineEntry struct {
+	At        string `json:"at"`
+	Source    string `json:"source"`
+	Type      string `json:"type"`
+	Summary   string `json:"summary"`
+	Actor     string `json:"actor,omitempty"`
+	Reference string `json:"reference,omitempty"`
+	Hash      string `json:"hash,omitempty"`
+	TxID      string `json:"tx_id,omitempty"`
+	Anchored  bool   `json:"anchored"`
+}
+
+// IncidentTimeline is an incident's history merged from every source in time order. Digest is a
+// SHA-256 over the entries, so a printed timeline can be checked against one rebuilt later.
+// Unavailable lists the off-ledger sources that could not be read.
+type IncidentTimeline struct {
+	IncidentID  string          `json:"incident_id"`
+	SubjectID   string          `json:"subject_id,omitempty"`
+	Status      string          `json:"status"`
+	Entries     []TimelineEntry `json:"entries"`
+	Counts      map[string]int  `json:"counts"`
+	Unavailable []string        `json:"unavailable,omitempty"`
+	Digest      string          `json:"digest"`
+	GeneratedAt string          `json:"generated_at"`
+}
+
+// IncidentVersion is one committed version of an incident
+type IncidentVersion struct {
+	TxID      string            `json:"tx_id"`
+	Timestamp string            `json:"timestamp"`
+	Deleted   bool              `json:"deleted"`
+	Incident  *IncidentDocument `json:"incident,omitempty"`
+}
+
+// TimelineAnnexure is the ledger record of a rendered annexure
+type TimelineAnnexure struct {
+	DocType        string `json:"doc_type"`
+	AnnexureID     string `json:"annexure_id"`
+	IncidentID     string `json:"incident_id"`
+	DocumentHash   string `json:"document_hash"`
+	TimelineDigest string `json:"timeline_digest"`
+	EntryCount     int    `json:"entry_count"`
+	GeneratedBy    string `json:"generated_by"`
+	CreatedAt      string `json:"created_at"`
+	OwnerOrg       string `json:"owner_org,omitempty"`
+	TxID           string `json:"tx_id"`
+}
+
+// TimelineAnnexureResult is a rendered annexure and where its hash was anchored
+type TimelineAnnexureResult struct {
+	AnnexureID     string
+	IncidentID     string
+	DocumentHash   string
+	TimelineDigest string
+	StorageKey     string
+	Document       []byte
+	Transaction    *TransactionResult
+}
+
+// timelineStorageKey is where an annexure's PDF lives in the evidence store
+func timelineStorageKey(incidentID, annexureID string) string {
+	return fmt.Sprintf("timeline/%s/%s.pdf", incidentID, annexureID)
+}
+
+// timelineTime normalises a timestamp to UTC so entries from every source sort together
+func timelineTime(value string) string {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return value
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// incidentVersionEntries describes what each committed version of the incident changed
+func incidentVersionEntries(versions []IncidentVersion) []TimelineEntry {
+	entries := []TimelineEntry{}
+	var previous *IncidentDocument
+	for _, version := range versions {
+		entry := TimelineEntry{At: timelineTime(version.Timestamp), Source: timelineLedger, TxID: version.TxID, Anchored: true}
+		current := version.Incident
+		switch {
+		case version.Deleted:
+			entry.Type, entry.Summary = "deleted", "Incident deleted"
+		case previous == nil:
+			entry.Type, entry.Actor, entry.Hash = "created", current.Reporter, current.IncidentSummaryHash
+			entry.Summary = strings.Join(strings.Fields(fmt.Sprintf("Incident reported %s %s", current.Severity, strings.ReplaceAll(current.Category, "_", " "))), " ")
+			if current.SubjectID != "" {
+				entry.Summary += " concerning " + current.SubjectID
+			}
+		default:
+			var changes []string
+			if current.Status != previous.Status {
+				changes = append(changes, fmt.Sprintf("status %s to %s", cmpOr(previous.Status, "open"), current.Status))
+			}
+			if current.Severity != previous.Severity {
+				changes = append(changes, fmt.Sprintf("severity %s to %s", cmpOr(previous.Severity, "unset"), current.Severity))
+			}
+			if current.IncidentSummaryHash != previous.IncidentSummaryHash {
+				changes = append(changes, "summary revised")
+				entry.Hash = current.IncidentSummaryHash
+			}
+			if current.MergedInto != "" && previous.MergedInto == "" {
+				changes = append(changes, "merged into "+current.MergedInto)
+			}
+			entry.Type = "updated"
+			if current.Status != previous.Status {
+				entry.Type = "status_changed"
+			}
+			entry.Summary = "Incident updated"
+			if len(changes) > 0 {
+				entry.Summary += ": " + strings.Join(changes, ", ")
+			}
+		}
+		if !version.Deleted {
+			previous = current
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func auditEntries(audits []AuditDocument) []TimelineEntry {
+	entries := make([]TimelineEntry, 0, len(audits))
+	for _, audit := range audits {
+		entries = append(entries, TimelineEntry{
+			At: timelineTime(audit.Timestamp), Source: timelineAudit, Type: strings.ToLower(audit.Action),
+			Summary: fmt.Sprintf("%s by %s", audit.Action, audit.Actor), Actor: audit.Actor, Hash: audit.AuditHash, TxID: audit.TxID, Anchored: true,
+		})
+	}
+	return entries
+}
+
+func evidenceEntries(evidence []EvidenceDocument) []TimelineEntry {
+	entries := make([]TimelineEntry, 0, len(evidence))
+	for _, e := range evidence {
+		entries = append(entries, TimelineEntry{
+			At: timelineTime(e.CreatedAt), Source: timelineEvidence, Type: "evidence_anchored",
+			Summary: fmt.Sprintf("Evidence %s anchored (%s)", e.EvidenceID, e.MediaType), Actor: e.UploadedBy,
+			Reference: e.EvidenceID, Hash: e.EvidenceHash, TxID: e.TxID, Anchored: true,
+		})
+	}
+	return entries
+}
+
+// locationEntries lists the pings and location anchors between from and to. Pings are the
+// gateway's recent trail; anchors are the ledger commitments covering them.
+func locationEntries(trail []TrailPoint, anchors []LocationAnchor, from, to string) []TimelineEntry {
+	entries := []TimelineEntry{}
+	for _, point := range trail {
+		at := timelineTime(point.RecordedAt)
+		if at < from || at > to {
+			continue
+		}
+		entries = append(entries, TimelineEntry{
+			At: at, Source: timelineLocation, Type: "ping",
+			Summary: fmt.Sprintf("Position %.6f, %.6f within %.0f m", point.Latitude, point.Longitude, point.Accuracy),
+		})
+	}
+	for _, anchor := range anchors {
+		if timelineTime(anchor.LastPingAt) < from || timelineTime(anchor.FirstPingAt) > to {
+			continue
+		}
+		entries = append(entries, TimelineEntry{
+			At: timelineTime(cmpOr(anchor.AnchoredAt, anchor.LastPingAt)), Source: timelineLocation, Type: "location_anchored",
+			Summary:   fmt.Sprintf("%d pings from %s to %s anchored", anchor.PingCount, anchor.FirstPingAt, anchor.LastPingAt),
+			Reference: anchor.AnchorID, Hash: anchor.Digest, TxID: anchor.TxID, Anchored: true,
+		})
+	}
+	return entries
+}
+
+// dispatchEntries lists each assignment's offer to a unit and what the unit did with it
+func dispatchEntries(assignments []AssignmentDocument) []TimelineEntry {
+	entries := []TimelineEntry{}
+	for _, a := range assignments {
+		entry := func(at, kind, summary, actor string) {
+			entries = append(entries, TimelineEntry{
+				At: timelineTime(at), Source: timelineDispatch, Type: kind, Summary: summary, Actor: actor,
+				Reference: a.AssignmentID, TxID: a.TxID, Anchored: true,
+			})
+		}
+		entry(a.AssignedAt, "assigned", fmt.Sprintf("Assigned to %s %s, %.1f km away (attempt %d)", a.UnitKind, a.UnitID, a.DistanceKm, a.Attempt), "")
+		if a.AcknowledgedAt != "" {
+			entry(a.AcknowledgedAt, "acknowledged", fmt.Sprintf("Acknowledged by %s", a.UnitID), a.AcknowledgedBy)
+		}
+		if a.DeclinedAt != "" {
+			entry(a.DeclinedAt, "declined", fmt.Sprintf("Declined by %s: %s", a.UnitID, a.DeclineReason), a.UnitID)
+		}
+		if a.EscalatedAt != "" {
+			entry(a.EscalatedAt, "escalated", fmt.Sprintf("Unacknowledged by %s, escalated to %s", a.UnitID, cmpOr(a.EscalatedTo, "the control room")), "")
+		}
+	}
+	return entries
+}
+
+// notificationEntries lists the deliveries of the incident's escalation chain
+func notificationEntries(c *EscalationCase) []TimelineEntry {
+	entries := []TimelineEntry{}
+	if c == nil {
+		return entries
+	}
+	for _, d := range c.Deliveries {
+		summary := fmt.Sprintf("%s notified by %s: %s", d.Recipient, d.Channel, d.Status)
+		if d.Error != "" {
+			summary += " (" + d.Error + ")"
+		}
+		entries = append(entries, TimelineEntry{
+			At: timelineTime(d.SentAt), Source: timelineNotification, Type: "notified", Summary: summary, Reference: d.MessageID,
+		})
+	}
+	if c.AcknowledgedAt != "" {
+		entries = append(entries, TimelineEntry{
+			At: timelineTime(c.AcknowledgedAt), Source: timelineNotification, Type: "acknowledged",
+			Summary: "Escalation acknowledged by " + c.AcknowledgedBy, Actor: c.AcknowledgedBy, Reference: c.NotificationID,
+		})
+	}
+	return entries
+}
+
+// sortTimeline orders entries by time, keeping each source's own order for entries at the same
+// second
+func sortTimeline(entries []TimelineEntry) {
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].At < entries[j].At })
+}
+
+// timelineDigest hashes the incident ID and one line per entry, in timeline order
+func timelineDigest(incidentID string, entries []TimelineEntry) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n", incidentID)
+	for _, e := range entries {
+		fmt.Fprintf(h, "%s|%s|%s|%s|%s|%s|%s|%t\n", e.At, e.Source, e.Type, e.Reference, e.Hash, e.TxID, e.Summary, e.Anchored)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// incidentHistory reads an incident's committed versions, oldest first
+func incidentHistory(ctx context.Context, incidentID string) ([]IncidentVersion, error) {
+	result, err := evaluateTransaction(ctx, "GetIncidentHistory", incidentID)
+	if err != nil {
+		return nil, err
+	}
+	return decodeDocument[[]IncidentVersion](result, "incident history")
+}
+
+// IncidentTimeline reconstructs an incident's history from its ledger versions, audit trail,
+// evidence and dispatch assignments on the ledger, and the subject's pings and the escalation
+// chain held by the gateway. Like the e-FIR it reads committed ledger state rather than the cache
+// or the off-chain index. A deleted incident still has a timeline for its owner.
+func (ledgerService) IncidentTimeline(ctx context.Context, incidentID string, req IncidentTimelineRequest) (IncidentTimeline, error) {
+	if errs := append(validateDocumentID("id", incidentID), req.Validate()...); len(errs) > 0 {
+		return IncidentTimeline{}, errs
+	}
+	versions, err := incidentHistory(ctx, incidentID)
+	if err != nil {
+		return IncidentTimeline{}, err
+	}
+	var incident *IncidentDocument
+	for _, version := range versions {
+		if version.Incident != nil {
+			incident = version.Incident
+		}
+	}
+	if incident == nil || !tenantFromContext(ctx).owns(incident.OwnerOrg) {
+		return IncidentTimeline{}, fmt.Errorf("the incident %s does not exist", incidentID)
+	}
+
+	now := time.Now().UTC()
+	timeline := IncidentTimeline{IncidentID: incidentID, SubjectID: incident.SubjectID, Status: cmpOr(incident.Status, "open"), Counts: map[string]int{}}
+	if versions[len(versions)-1].Deleted {
+		timeline.Status = "deleted"
+	}
+	var entries []TimelineEntry
+	if req.includes(timelineLedger) {
+		entries = append(entries, incidentVersionEntries(versions)...)
+	}
+	if req.includes(timelineAudit) {
+		audits, err := auditsByTargetFromLedger(ctx, incidentID)
+		if err != nil {
+			return IncidentTimeline{}, err
+		}
+		entries = append(entries, auditEntries(audits)...)
+	}
+	if req.includes(timelineEvidence) {
+		evidence, err := evidenceByIncidentFromLedger(ctx, incidentID, EvidenceListRequest{})
+		if err != nil {
+			return IncidentTimeline{}, err
+		}
+		entries = append(entries, evidenceEntries(evidence)...)
+	}
+	if req.includes(timelineDispatch) {
+		assignments, err := ledger.ListAssignments(ctx, AssignmentListRequest{Subject: incidentID})
+		if err != nil {
+			return IncidentTimeline{}, err
+		}
+		entries = append(entries, dispatchEntries(assignments)...)
+	}
+	if req.includes(timelineLocation) && incident.SubjectID != "" {
+		created, _ := time.Parse(time.RFC3339, incident.CreatedAt)
+		from := created.Add(-timelineLocationLead).UTC().Format(time.RFC3339)
+		to := timelineTime(cmpOr(incident.ResolvedAt, now.Format(time.RFC3339)))
+		var trail []TrailPoint
+		if locations != nil {
+			if trail, err = locations.Trail(ctx, incident.SubjectID); err != nil {
+				logWithContext(ctx, "Failed to read the trail of %s for timeline %s: %v", incident.SubjectID, incidentID, err)
+				timeline.Unavailable = append(timeline.Unavailable, timelineLocation)
+			}
+		}
+		anchors, err := ledger.ListLocationAnchors(ctx, incident.SubjectID)
+		if err != nil {
+			return IncidentTimeline{}, err
+		}
+		entries = append(entries, locationEntries(trail, anchors, from, to)...)
+	}
+	if req.includes(timelineNotification) && orchestrator != nil {
+		c, err := orchestrator.store.load(ctx, escalationID("incident", incidentID))
+		if err != nil {
+			logWithContext(ctx, "Failed to read the escalation of %s for its timeline: %v", incidentID, err)
+			timeline.Unavailable = append(timeline.Unavailable, timelineNotification)
+		}
+		entries = append(entries, notificationEntries(c)...)
+	}
+
+	if entries == nil {
+		entries = []TimelineEntry{}
+	}
+	sortTimeline(entries)
+	for _, entry := range entries {
+		timeline.Counts[entry.Source]++
+	}
+	timeline.Entries = entries
+	timeline.Digest = timelineDigest(incidentID, entries)
+	timeline.GeneratedAt = now.Format(time.RFC3339)
+	return timeline, nil
+}
+
+// renderTimelineAnnexure lays out a timeline as a PDF annexure
+func renderTimelineAnnexure(annexureID string, timeline IncidentTimeline, req TimelineAnnexureRequest) []byte {
+	doc := newPDFDocument()
+	doc.Centered("ANNEXURE: INCIDENT TIMELINE", 16, true)
+	doc.Space(6)
+	doc.Field("Annexure ID", annexureID, 10)
+	if req.CaseReference != "" {
+		doc.Field("Case reference", req.CaseReference, 10)
+	}
+	doc.Field("Incident ID", timeline.IncidentID, 10)
+	if timeline.SubjectID != "" {
+		doc.Field("Subject DID", timeline.SubjectID, 10)
+	}
+	doc.Field("Incident status", timeline.Status, 10)
+	doc.Field("Generated at", timeline.GeneratedAt, 10)
+	doc.Field("Generated by", req.GeneratedBy, 10)
+	doc.Field("Entries", strconv.Itoa(len(timeline.Entries)), 10)
+	doc.Field("Timeline digest", timeline.Digest, 10)
+	if len(timeline.Unavailable) > 0 {
+		doc.Field("Sources unavailable", strings.Join(timeline.Unavailable, ", "), 10)
+	}
+
+	doc.Space(6)
+	doc.Paragraph("Chronology", 12, true)
+	doc.Rule()
+	for i, e := range timeline.Entries {
+		line := fmt.Sprintf("%d. %s [%s] %s", i+1, e.At, e.Source, e.Summary)
+		doc.Indented(pdfMargin, line, 9, false)
+		var refs []string
+		if e.Reference != "" {
+			refs = append(refs, "ref "+e.Reference)
+		}
+		if e.Hash != "" {
+			refs = append(refs, "sha256 "+e.Hash)
+		}
+		if e.TxID != "" {
+			refs = append(refs, "tx "+e.TxID)
+		}
+		if !e.Anchored {
+			refs = append(refs, "not anchored on the ledger")
+		}
+		if len(refs) > 0 {
+			doc.Indented(pdfMargin+18, strings.Join(refs, "; "), 8, false)
+		}
+	}
+
+	doc.Space(6)
+	doc.Rule()
+	doc.Paragraph("Entries with a transaction ID can be checked against that transaction on the permissioned ledger. "+
+		"The timeline digest is the SHA-256 of the incident ID and one line per entry in the order printed; "+
+		"the hash of this document is recorded on the ledger under the annexure ID.", 9, false)
+	return doc.Bytes()
+}
+
+// GenerateTimelineAnnexure renders the incident's full timeline as a PDF, stores it alongside the
+// evidence files and anchors its hash and the timeline digest on the ledger
+func (s ledgerService) GenerateTimelineAnnexure(ctx context.Context, incidentID string, req TimelineAnnexureRequest) (*TimelineAnnexureResult, error) {
+	if err := validateMutation(incidentID, req); err != nil {
+		return nil, err
+	}
+	timeline, err := s.IncidentTimeline(ctx, incidentID, IncidentTimelineRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	suffix := make([]byte, 3)
+	rand.Read(suffix)
+	annexureID := fmt.Sprintf("ANX-%s-%s", time.Now().UTC().Format("20060102T150405Z"), hex.EncodeToString(suffix))
+	document := renderTimelineAnnexure(annexureID, timeline, req)
+	sum := sha256.Sum256(document)
+	result := &TimelineAnnexureResult{
+		AnnexureID:     annexureID,
+		IncidentID:     incidentID,
+		DocumentHash:   hex.EncodeToString(sum[:]),
+		TimelineDigest: timeline.Digest,
+		StorageKey:     timelineStorageKey(incidentID, annexureID),
+		Document:       document,
+	}
+
+	if err := evidenceStore.Put(ctx, result.StorageKey, bytes.NewReader(document), int64(len(document)), "application/pdf"); err != nil {
+		return nil, fmt.Errorf("failed to store timeline annexure: %w", err)
+	}
+	result.Transaction, err = submitTransaction(ctx, "RecordTimelineAnnexure", annexureID, incidentID, result.DocumentHash, result.TimelineDigest, strconv.Itoa(len(timeline.Entries)), req.GeneratedBy)
+	if err != nil {
+		// Do not keep a document the ledger has no record of
+		if delErr := evidenceStore.Delete(ctx, result.StorageKey); delErr != nil {
+			logWithContext(ctx, "Failed to remove orphaned annexure %s: %v", result.StorageKey, delErr)
+		}
+		return nil, err
+	}
+	return result, nil
+}
+
+// ListTimelineAnnexures returns the annexures recorded for an incident, latest first
+func (s ledgerService) ListTimelineAnnexures(ctx context.Context, incidentID string) ([]TimelineAnnexure, error) {
+	if _, err := s.ownedIncident(ctx, incidentID); err != nil {
+		return nil, err
+	}
+	result, err := evaluateTransaction(ctx, "GetTimelineAnnexuresByIncident", incidentID)
+	if err != nil {
+		return nil, err
+	}
+	annexures, err := decodeDocument[[]TimelineAnnexure](result, "timeline annexure")
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(annexures, func(i, j int) bool { return annexures[i].CreatedAt > annexures[j].CreatedAt })
+	return annexures, nil
+}
+
+func getIncidentTimeline(c *gin.Context) {
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	var req IncidentTimelineRequest
+	if !bindQuery(c, &req) {
+		return
+	}
+	timeline, err := ledger.IncidentTimeline(c.Request.Context(), id, req)
+	if err != nil {
+		respondServiceError(c, "Failed to reconstruct incident timeline", err)
+		return
+	}
+	respondData(c, http.StatusOK, timeline)
+}
+
+// generateTimelineAnnexure returns the PDF itself when the client accepts application/pdf,
+// otherwise the standard envelope with the document base64 encoded
+func generateTimelineAnnexure(c *gin.Context) {
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	var req TimelineAnnexureRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+
+	result, err := ledger.GenerateTimelineAnnexure(c.Request.Context(), id, req)
+	if err != nil {
+		respondServiceError(c, "Failed to generate timeline annexure", err)
+		return
+	}
+
+	if c.NegotiateFormat(gin.MIMEJSON, "application/pdf") == "application/pdf" {
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.pdf"`, result.AnnexureID))
+		c.Header("X-Annexure-ID", result.AnnexureID)
+		c.Header("X-Document-Hash", result.DocumentHash)
+		c.Header("X-Timeline-Digest", result.TimelineDigest)
+		c.Header("X-Transaction-ID", result.Transaction.TxID)
+		c.Header("X-Block-Number", strconv.FormatUint(result.Transaction.BlockNumber, 10))
+		c.Data(http.StatusCreated, "application/pdf", result.Document)
+		return
+	}
+
+	respondCommitted(c, http.StatusCreated, gin.H{
+		"message":        "Timeline annexure generated successfully",
+		"annexureID":     result.AnnexureID,
+		"incidentID":     result.IncidentID,
+		"documentHash":   result.DocumentHash,
+		"timelineDigest": result.TimelineDigest,
+		"storageKey":     result.StorageKey,
+		"document":       result.Document,
+	}, result.Transaction)
+}
+
+func listTimelineAnnexures(c *gin.Context) {
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	annexures, err := ledger.ListTimelineAnnexures(c.Request.Context(), id)
+	if err != nil {
+		respondServiceError(c, "Failed to list timeline annexures", err)
+		return
+	}
+	respondData(c, http.StatusOK, annexures)
+}
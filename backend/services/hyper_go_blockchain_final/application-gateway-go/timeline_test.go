@@ -0,0 +1,93 @@
+package gateway
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIncidentVersionEntries(t *testing.T) {
+	created := IncidentDocument{IncidentID: "INC-1", IncidentSummaryHash: "h1", Reporter: "officer_1", Severity: "high", Category: "medical_emergency", SubjectID: "did:sih:1"}
+	acknowledged := created
+	acknowledged.Status = "acknowledged"
+	revised := acknowledged
+	revised.IncidentSummaryHash = "h2"
+	versions := []IncidentVersion{
+		{TxID: "tx1", Timestamp: "2025-09-20T18:00:00+05:30", Incident: &created},
+		{TxID: "tx2", Timestamp: "2025-09-20T12:40:00Z", Incident: &acknowledged},
+		{TxID: "tx3", Timestamp: "2025-09-20T13:00:00Z", Incident: &revised},
+		{TxID: "tx4", Timestamp: "2025-09-20T14:00:00Z", Deleted: true},
+	}
+
+	entries := incidentVersionEntries(versions)
+	if len(entries) != 4 {
+		t.Fatalf("expected an entry per version, got %+v", entries)
+	}
+	want := []struct{ at, kind, summary string }{
+		{"2025-09-20T12:30:00Z", "created", "Incident reported high medical emergency concerning did:sih:1"},
+		{"2025-09-20T12:40:00Z", "status_changed", "Incident updated: status open to acknowledged"},
+		{"2025-09-20T13:00:00Z", "updated", "Incident updated: summary revised"},
+		{"2025-09-20T14:00:00Z", "deleted", "Incident deleted"},
+	}
+	for i, w := range want {
+		e := entries[i]
+		if e.At != w.at || e.Type != w.kind || e.Summary != w.summary || e.Source != timelineLedger || !e.Anchored {
+			t.Errorf("entry %d: expected %+v, got %+v", i, w, e)
+		}
+	}
+	if entries[0].Hash != "h1" || entries[2].Hash != "h2" || entries[1].Hash != "" {
+		t.Errorf("expected summary hashes only where the summary was written, got %+v", entries)
+	}
+}
+
+func TestTimelineOrderAndDigest(t *testing.T) {
+	entries := append(dispatchEntries([]AssignmentDocument{{
+		AssignmentID: "ASG-1", UnitID: "PS-1", UnitKind: "police", Attempt: 1, DistanceKm: 1.2,
+		AssignedAt: "2025-09-20T12:31:00Z", EscalatedAt: "2025-09-20T12:33:00Z", TxID: "tx5",
+	}}), auditEntries([]AuditDocument{{Action: "CREATE_INCIDENT", Actor: "officer_1", Timestamp: "2025-09-20T12:30:00Z", TxID: "tx1"}})...)
+	entries = append(entries, notificationEntries(&EscalationCase{
+		AcknowledgedAt: "2025-09-20T12:32:00Z", AcknowledgedBy: "duty_officer",
+		Deliveries: []EscalationDelivery{{NotificationStatus: NotificationStatus{Recipient: "+910000000000", Channel: "sms", Status: "sent"}, SentAt: "2025-09-20T12:31:00Z"}},
+	})...)
+	entries = append(entries, locationEntries(
+		[]TrailPoint{{Latitude: 25.57, Longitude: 91.88, Accuracy: 12, RecordedAt: "2025-09-20T12:29:00Z"}, {RecordedAt: "2025-09-20T09:00:00Z"}},
+		[]LocationAnchor{{AnchorID: "LOC-1", PingCount: 3, FirstPingAt: "2025-09-20T12:00:00Z", LastPingAt: "2025-09-20T12:29:00Z", AnchoredAt: "2025-09-20T12:35:00Z", TxID: "tx6"}},
+		"2025-09-20T10:30:00Z", "2025-09-20T14:00:00Z")...)
+	sortTimeline(entries)
+
+	var order []string
+	for _, e := range entries {
+		order = append(order, e.Source+":"+e.Type)
+	}
+	want := "location:ping audit:create_incident dispatch:assigned notification:notified notification:acknowledged dispatch:escalated location:location_anchored"
+	if got := strings.Join(order, " "); got != want {
+		t.Fatalf("expected %s, got %s", want, got)
+	}
+	if entries[0].Anchored || !entries[1].Anchored || entries[3].Anchored {
+		t.Errorf("expected only ledger records anchored, got %+v", entries)
+	}
+
+	digest := timelineDigest("INC-1", entries)
+	if len(digest) != 64 || digest != timelineDigest("INC-1", entries) {
+		t.Fatalf("expected a stable SHA-256, got %q", digest)
+	}
+	if timelineDigest("INC-2", entries) == digest {
+		t.Error("expected the incident ID to be part of the digest")
+	}
+	entries[2].Summary += "."
+	if timelineDigest("INC-1", entries) == digest {
+		t.Error("expected a changed entry to change the digest")
+	}
+}
+
+func TestIncidentTimelineRequestValidation(t *testing.T) {
+	if errs := (IncidentTimelineRequest{Sources: []string{"ledger", "notification"}}).Validate(); len(errs) > 0 {
+		t.Fatalf("unexpected errors %v", errs)
+	}
+	errs := IncidentTimelineRequest{Sources: []string{"ledger", "cctv"}}.Validate()
+	if len(errs) != 1 || errs[0].Field != "source[1]" {
+		t.Errorf("expected the unknown source rejected, got %v", errs)
+	}
+	if !(IncidentTimelineRequest{}).includes(timelineDispatch) || (IncidentTimelineRequest{Sources: []string{"audit"}}).includes(timelineDispatch) {
+		t.Error("expected every source by default and only the listed ones otherwise")
+	}
+}
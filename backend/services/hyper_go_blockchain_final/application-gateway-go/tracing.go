@@ -0,0 +1,134 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	tracerName          = "sih-application-gateway"
+	defaultServiceName  = "sih-chaincode-api"
+	tracingShutdownWait = 5 * time.Second
+)
+
+// tracerProvider is nil until initTracing runs, leaving OpenTelemetry's no-op provider in place
+var tracerProvider *sdktrace.TracerProvider
+
+// initTracing installs an OpenTelemetry tracer provider. Spans are exported over OTLP/HTTP when
+// the standard OTEL_EXPORTER_OTLP_* variables name an endpoint; without one they are still created,
+// so trace IDs appear in logs, but nothing is exported.
+func initTracing(ctx context.Context) {
+	serviceName := getEnv("OTEL_SERVICE_NAME", defaultServiceName)
+	res, err := resource.New(ctx,
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+		resource.WithSchemaURL(semconv.SchemaURL),
+		resource.WithAttributes(semconv.ServiceName(serviceName), semconv.ServiceVersion(apiVersion)),
+	)
+	if err != nil {
+		log.Printf("🔭 Incomplete trace resource: %v", err)
+	}
+	opts := []sdktrace.TracerProviderOption{sdktrace.WithResource(res)}
+
+	endpoint := getEnv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"))
+	if endpoint == "" {
+		log.Println("🔭 OTLP endpoint not configured, trace export disabled")
+	} else {
+		// The exporter reads the endpoint, headers and timeout from the OTEL_* variables itself
+		exporter, err := otlptracehttp.New(ctx)
+		if err != nil {
+			log.Printf("🔭 Trace export disabled: %v", err)
+		} else {
+			opts = append(opts, sdktrace.WithBatcher(exporter))
+			log.Printf("🔭 Exporting traces to %s as %s", endpoint, serviceName)
+		}
+	}
+
+	tracerProvider = sdktrace.NewTracerProvider(opts...)
+	otel.SetTracerProvider(tracerProvider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+}
+
+// shutdownTracing flushes buffered spans
+func shutdownTracing() {
+	if tracerProvider == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), tracingShutdownWait)
+	defer cancel()
+	if err := tracerProvider.Shutdown(ctx); err != nil {
+		log.Printf("Failed to flush spans: %v", err)
+	}
+}
+
+// startSpan starts a child of the span held in ctx, or a new root span when there is none
+func startSpan(ctx context.Context, name string, kind trace.SpanKind) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithSpanKind(kind))
+}
+
+// recordSpanError marks the span as failed, if err is not nil
+func recordSpanError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// tracingMiddleware opens a server span per request, continuing any incoming W3C trace context
+func tracingMiddleware() gin.HandlerFunc {
+	return otelgin.Middleware(getEnv("OTEL_SERVICE_NAME", defaultServiceName))
+}
+
+// traceResponseMiddleware returns the request's span context in a traceparent response header, so
+// clients can quote it when reporting a problem
+func traceResponseMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		otel.GetTextMapPropagator().Inject(c.Request.Context(), propagation.HeaderCarrier(c.Writer.Header()))
+		c.Next()
+	}
+}
+
+// requestLogger logs each request with its trace and span identifiers so logs can be joined to traces
+func requestLogger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		traceID, spanID := "-", "-"
+		if sc := trace.SpanContextFromContext(c.Request.Context()); sc.IsValid() {
+			traceID, spanID = sc.TraceID().String(), sc.SpanID().String()
+		}
+		log.Printf("%s %s %d %s trace_id=%s span_id=%s",
+			c.Request.Method, c.Request.URL.Path, c.Writer.Status(), time.Since(start).Round(time.Microsecond), traceID, spanID)
+	}
+}
+
+// logWithContext writes a log line annotated with the trace of the active span
+func logWithContext(ctx context.Context, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		message += fmt.Sprintf(" trace_id=%s span_id=%s", sc.TraceID(), sc.SpanID())
+	}
+	log.Print(message)
+}
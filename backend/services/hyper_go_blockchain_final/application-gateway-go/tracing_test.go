@@ -0,0 +1,164 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// withTracing runs initTracing for one test, putting back OpenTelemetry's globals afterwards
+func withTracing(t *testing.T) {
+	t.Helper()
+	provider, propagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	initTracing(context.Background())
+	t.Cleanup(func() {
+		shutdownTracing()
+		tracerProvider = nil
+		otel.SetTracerProvider(provider)
+		otel.SetTextMapPropagator(propagator)
+	})
+}
+
+func TestTracingPropagation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+	withTracing(t)
+
+	var seen trace.SpanContext
+	router := gin.New()
+	router.Use(tracingMiddleware(), traceResponseMiddleware())
+	router.GET("/health", func(c *gin.Context) {
+		seen = trace.SpanContextFromContext(c.Request.Context())
+		c.Status(http.StatusOK)
+	})
+	get := func(traceparent string) string {
+		req := httptest.NewRequest(http.MethodGet, "/health", nil)
+		if traceparent != "" {
+			req.Header.Set("traceparent", traceparent)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Header().Get("traceparent")
+	}
+
+	const remoteTrace, remoteSpan = "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+	echoed := get("00-" + remoteTrace + "-" + remoteSpan + "-01")
+	if seen.TraceID().String() != remoteTrace || seen.SpanID().String() == remoteSpan || !seen.IsSampled() {
+		t.Errorf("expected a sampled child of the incoming trace, got %s/%s", seen.TraceID(), seen.SpanID())
+	}
+	if want := "00-" + remoteTrace + "-" + seen.SpanID().String() + "-01"; echoed != want {
+		t.Errorf("got traceparent %q, want %q", echoed, want)
+	}
+
+	// The caller's decision not to sample is kept
+	if echoed := get("00-" + remoteTrace + "-" + remoteSpan + "-00"); seen.IsSampled() || !strings.HasSuffix(echoed, "-00") {
+		t.Errorf("expected an unsampled trace kept unsampled, got %q", echoed)
+	}
+
+	for _, header := range []string{"", "00-" + remoteTrace + "-" + remoteSpan, "00-not-hex-01", "00-00000000000000000000000000000000-" + remoteSpan + "-01"} {
+		echoed := get(header)
+		if !seen.IsValid() || seen.TraceID().String() == remoteTrace || !strings.HasPrefix(echoed, "00-"+seen.TraceID().String()+"-") {
+			t.Errorf("traceparent %q: expected a new root trace, got %s and %q", header, seen.TraceID(), echoed)
+		}
+	}
+}
+
+func TestTracingExport(t *testing.T) {
+	var mu sync.Mutex
+	var exported coltracepb.ExportTraceServiceRequest
+	var contentType, authorization string
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		if r.URL.Path != "/v1/traces" {
+			t.Errorf("spans posted to %s", r.URL.Path)
+		}
+		contentType, authorization = r.Header.Get("Content-Type"), r.Header.Get("Authorization")
+		var request coltracepb.ExportTraceServiceRequest
+		if err := proto.Unmarshal(body, &request); err != nil {
+			t.Errorf("collector got an undecodable payload: %v", err)
+		}
+		exported.ResourceSpans = append(exported.ResourceSpans, request.ResourceSpans...)
+		w.Header().Set("Content-Type", "application/x-protobuf")
+	}))
+	defer collector.Close()
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", collector.URL)
+	t.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "authorization=Bearer token")
+	t.Setenv("OTEL_SERVICE_NAME", "sih-test")
+	withTracing(t)
+
+	ctx, submit := startSpan(context.Background(), "fabric.submit CreateIncident", trace.SpanKindClient)
+	submit.SetAttributes(attribute.String("fabric.channel", "mychannel"), attribute.Int64("fabric.block_number", 42))
+	_, endorse := startSpan(ctx, "fabric.endorse", trace.SpanKindClient)
+	recordSpanError(endorse, errors.New("endorsement policy failure"))
+	endorse.End()
+	submit.End()
+	shutdownTracing()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if contentType != "application/x-protobuf" || authorization != "Bearer token" {
+		t.Errorf("unexpected export request headers %q, %q", contentType, authorization)
+	}
+	if len(exported.ResourceSpans) != 1 {
+		t.Fatalf("expected one resource, got %d", len(exported.ResourceSpans))
+	}
+	resource := map[string]string{}
+	for _, kv := range exported.ResourceSpans[0].Resource.Attributes {
+		resource[kv.Key] = kv.Value.GetStringValue()
+	}
+	if resource["service.name"] != "sih-test" || resource["service.version"] != apiVersion {
+		t.Errorf("unexpected resource %v", resource)
+	}
+	scopes := exported.ResourceSpans[0].ScopeSpans
+	if len(scopes) != 1 || scopes[0].Scope.Name != tracerName || len(scopes[0].Spans) != 2 {
+		t.Fatalf("expected both spans under %s, got %v", tracerName, scopes)
+	}
+	spans := map[string]*tracepb.Span{}
+	for _, span := range scopes[0].Spans {
+		spans[span.Name] = span
+	}
+	parent, child := spans["fabric.submit CreateIncident"], spans["fabric.endorse"]
+	if parent == nil || child == nil {
+		t.Fatalf("missing spans in %v", spans)
+	}
+	if string(child.TraceId) != string(parent.TraceId) || string(child.ParentSpanId) != string(parent.SpanId) || len(parent.ParentSpanId) != 0 {
+		t.Error("expected the endorsement exported as a child of the submit")
+	}
+	if parent.Kind != tracepb.Span_SPAN_KIND_CLIENT || parent.EndTimeUnixNano < parent.StartTimeUnixNano {
+		t.Errorf("unexpected submit span %v", parent)
+	}
+	attributes := map[string]string{}
+	for _, kv := range parent.Attributes {
+		if kv.Value.GetIntValue() != 0 {
+			attributes[kv.Key] = "int"
+		} else {
+			attributes[kv.Key] = kv.Value.GetStringValue()
+		}
+	}
+	if attributes["fabric.channel"] != "mychannel" || attributes["fabric.block_number"] != "int" {
+		t.Errorf("unexpected attributes %v", attributes)
+	}
+	if child.Status.Code != tracepb.Status_STATUS_CODE_ERROR || child.Status.Message != "endorsement policy failure" || len(child.Events) != 1 {
+		t.Errorf("expected the endorsement failure recorded, got %v", child.Status)
+	}
+	if parent.Status.Code != tracepb.Status_STATUS_CODE_UNSET {
+		t.Errorf("expected the submit left unset, got %v", parent.Status)
+	}
+}
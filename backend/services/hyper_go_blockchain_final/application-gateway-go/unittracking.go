@@ -0,0 +1,517 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	unitTrackPrefix        = "sih:dispatch:track:"
+	maxUnitFixBatch        = 500
+	maxUnitTrackPoints     = 5000
+	defaultUnitTrackWindow = 24 * time.Hour
+
+	// Where a unit on the dashboard layer is drawn: its fresh live position or its station
+	unitLocatedLive    = "live"
+	unitLocatedStation = "station"
+)
+
+// UnitFix is one GPS fix from a unit's vehicle or handset
+type UnitFix struct {
+	Latitude   *float64 `json:"latitude"`
+	Longitude  *float64 `json:"longitude"`
+	Accuracy   float64  `json:"accuracy"`
+	Speed      *float64 `json:"speed,omitempty"`
+	Heading    *float64 `json:"heading,omitempty"`
+	RecordedAt string   `json:"recorded_at"`
+}
+
+// validate adds the fix's errors under prefix, such as "fixes[3]."
+func (f UnitFix) validate(v *fieldValidator, prefix string, now time.Time) {
+	if f.Latitude == nil {
+		v.add(prefix+"latitude", "is required")
+	} else if *f.Latitude < -90 || *f.Latitude > 90 {
+		v.add(prefix+"latitude", "must be between -90 and 90")
+	}
+	if f.Longitude == nil {
+		v.add(prefix+"longitude", "is required")
+	} else if *f.Longitude < -180 || *f.Longitude > 180 {
+		v.add(prefix+"longitude", "must be between -180 and 180")
+	}
+	if f.Accuracy < 0 {
+		v.add(prefix+"accuracy", "must not be negative")
+	}
+	if f.Speed != nil && *f.Speed < 0 {
+		v.add(prefix+"speed", "must not be negative")
+	}
+	if f.Heading != nil && (*f.Heading < 0 || *f.Heading >= 360) {
+		v.add(prefix+"heading", "must be at least 0 and below 360")
+	}
+	if t, ok := v.rfc3339(prefix+"recorded_at", f.RecordedAt); ok {
+		switch {
+		case t.After(now.Add(maxLocationPingClockSkew)):
+			v.add(prefix+"recorded_at", "must not be in the future")
+		case t.Before(now.Add(-maxLocationPingAge)):
+			v.add(prefix+"recorded_at", "must be within the last %s", maxLocationPingAge)
+		}
+	}
+}
+
+// position is the fix as stored, in UTC
+func (f UnitFix) position() UnitPosition {
+	recorded, _ := time.Parse(time.RFC3339, f.RecordedAt)
+	return UnitPosition{
+		Latitude:   *f.Latitude,
+		Longitude:  *f.Longitude,
+		Accuracy:   f.Accuracy,
+		Speed:      f.Speed,
+		Heading:    f.Heading,
+		ReportedAt: recorded.UTC().Format(time.RFC3339),
+	}
+}
+
+// UnitFixesRequest is a batch of fixes a unit's tracker queued since its last upload
+type UnitFixesRequest struct {
+	Fixes []UnitFix `json:"fixes" binding:"required"`
+}
+
+func (r UnitFixesRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	if len(r.Fixes) == 0 || len(r.Fixes) > maxUnitFixBatch {
+		v.add("fixes", "must contain between 1 and %d fixes", maxUnitFixBatch)
+	}
+	now := time.Now()
+	for i, fix := range r.Fixes {
+		fix.validate(&v, fmt.Sprintf("fixes[%d].", i), now)
+	}
+	return v.errors
+}
+
+// UnitFixesResult acknowledges a batch. Position is the unit's live position afterwards, which a
+// batch of fixes older than it leaves unchanged.
+type UnitFixesResult struct {
+	UnitID   string       `json:"unit_id"`
+	Accepted int          `json:"accepted"`
+	Position UnitPosition `json:"position"`
+}
+
+// UnitTrackRequest selects a window of a unit's track, the last day by default
+type UnitTrackRequest struct {
+	From  string `form:"from"`
+	To    string `form:"to"`
+	Limit int    `form:"limit"`
+}
+
+func (r UnitTrackRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.timeRange(r.From, r.To)
+	if r.Limit < 0 || r.Limit > maxUnitTrackPoints {
+		v.add("limit", "must be between 1 and %d", maxUnitTrackPoints)
+	}
+	return v.errors
+}
+
+// UnitTrack is a unit's fixes over a window, oldest first. Truncated is set when the window held
+// more fixes than the limit, which keeps the earliest.
+type UnitTrack struct {
+	UnitID    string         `json:"unit_id"`
+	From      string         `json:"from"`
+	To        string         `json:"to"`
+	Points    []UnitPosition `json:"points"`
+	Truncated bool           `json:"truncated"`
+}
+
+// UnitLayerRequest narrows the dashboard's unit layer to one district
+type UnitLayerRequest struct {
+	District string `form:"district"`
+}
+
+func (r UnitLayerRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	if r.District != "" {
+		v.identifier("district", r.District)
+	}
+	return v.errors
+}
+
+// LayerLink points from a marker to the nearest marker of the other layer
+type LayerLink struct {
+	ID         string  `json:"id"`
+	DistanceKm float64 `json:"distance_km"`
+}
+
+// UnitMarker is a unit on the dashboard map. Located says whether it is drawn at a fresh live
+// position or at its station; ReportedAt is only set for a live position.
+type UnitMarker struct {
+	UnitID          string     `json:"unit_id"`
+	Name            string     `json:"name"`
+	Kind            string     `json:"kind"`
+	Status          string     `json:"status"`
+	District        string     `json:"district"`
+	Latitude        float64    `json:"latitude"`
+	Longitude       float64    `json:"longitude"`
+	Located         string     `json:"located"`
+	ReportedAt      string     `json:"reported_at,omitempty"`
+	NearestIncident *LayerLink `json:"nearest_incident,omitempty"`
+}
+
+// IncidentMarker is a located open incident on the dashboard map, at its geohash cell's center, with
+// the nearest unit of any status and the nearest available one
+type IncidentMarker struct {
+	IncidentID       string     `json:"incident_id"`
+	Severity         string     `json:"severity"`
+	Status           string     `json:"status"`
+	Category         string     `json:"category,omitempty"`
+	District         string     `json:"district"`
+	Latitude         float64    `json:"latitude"`
+	Longitude        float64    `json:"longitude"`
+	NearestUnit      *LayerLink `json:"nearest_unit,omitempty"`
+	NearestAvailable *LayerLink `json:"nearest_available,omitempty"`
+}
+
+// UnitLayer is the dashboard's blue-force layer: every unit and the tenant's located open incidents
+type UnitLayer struct {
+	GeneratedAt string           `json:"generated_at"`
+	Units       []UnitMarker     `json:"units"`
+	Incidents   []IncidentMarker `json:"incidents"`
+}
+
+// unitFeed receives unit fixes over MQTT, on topics carrying the unit ID in a + level
+type unitFeed struct {
+	mqtt   *mqttClient
+	topics []string
+}
+
+var unitFixes *unitFeed
+
+// initUnitTracking subscribes to unit fixes when dispatch is enabled and MQTT_BROKER_URL is set.
+// Runs after initDispatch.
+func initUnitTracking() {
+	if dispatcher == nil {
+		return
+	}
+	broker := getEnv("MQTT_BROKER_URL", "")
+	topics := splitList(getEnv("UNIT_MQTT_TOPICS", "sih/units/+/position"))
+	if broker == "" || len(topics) == 0 {
+		log.Println("🛰️ Unit fixes accepted through the API only")
+		return
+	}
+	for _, topic := range topics {
+		if strings.Count(topic, "+") == 0 {
+			panic(fmt.Errorf("UNIT_MQTT_TOPICS topic %q needs a + level for the unit ID", topic))
+		}
+	}
+	unitFixes = &unitFeed{mqtt: mqttClientFromEnv(broker, "-units"), topics: topics}
+	log.Printf("🛰️ Receiving unit fixes via %s on %s", unitFixes.mqtt.addr, strings.Join(topics, ", "))
+}
+
+func (f *unitFeed) run(ctx context.Context) {
+	f.mqtt.run(ctx, f.topics, f.handle)
+}
+
+// handle records one fix. Fixes from unknown units and malformed fixes are logged and acknowledged,
+// since redelivery would not fix them; a fix that could not be stored is left for redelivery.
+func (f *unitFeed) handle(ctx context.Context, msg mqttMessage) error {
+	unitID := ""
+	for _, filter := range f.topics {
+		if id, ok := mqttTopicLevel(filter, msg.topic); ok {
+			unitID = id
+			break
+		}
+	}
+	if policeUnitByID(unitID) == nil {
+		log.Printf("🛰️ Ignoring a fix on %s from an unknown unit", msg.topic)
+		return nil
+	}
+	var fix UnitFix
+	if err := json.Unmarshal(msg.payload, &fix); err != nil {
+		log.Printf("🛰️ Ignoring a malformed fix from %s: %v", unitID, err)
+		return nil
+	}
+	now := time.Now()
+	if fix.RecordedAt == "" {
+		fix.RecordedAt = now.UTC().Format(time.RFC3339)
+	}
+	var v fieldValidator
+	if fix.validate(&v, "", now); len(v.errors) > 0 {
+		log.Printf("🛰️ Ignoring a fix from %s: %v", unitID, v.errors)
+		return nil
+	}
+	if _, err := dispatcher.recordFixes(ctx, unitID, []UnitFix{fix}, now); err != nil {
+		return fmt.Errorf("failed to record a fix from %s: %w", unitID, err)
+	}
+	return nil
+}
+
+// recordFixes adds the fixes to the unit's track and moves its live position to the newest of them,
+// unless the unit already reported a newer one
+func (d *dispatchService) recordFixes(ctx context.Context, unitID string, fixes []UnitFix, now time.Time) (UnitPosition, error) {
+	points := make([]UnitPosition, 0, len(fixes))
+	for _, fix := range fixes {
+		points = append(points, fix.position())
+	}
+	slices.SortStableFunc(points, func(a, b UnitPosition) int { return strings.Compare(a.ReportedAt, b.ReportedAt) })
+	if err := d.store.appendTrack(ctx, unitID, points, now.Add(-d.trackRetention)); err != nil {
+		return UnitPosition{}, err
+	}
+
+	newest := points[len(points)-1]
+	positions, err := d.store.positions(ctx)
+	if err != nil {
+		return UnitPosition{}, err
+	}
+	if current, ok := positions[unitID]; ok && current.ReportedAt > newest.ReportedAt {
+		return current, nil
+	}
+	return newest, d.store.setPosition(ctx, unitID, newest)
+}
+
+// track returns the unit's fixes recorded in [from, to], oldest first
+func (d *dispatchService) track(ctx context.Context, unitID string, req UnitTrackRequest, now time.Time) (UnitTrack, error) {
+	to, from := now, now.Add(-defaultUnitTrackWindow)
+	if req.To != "" {
+		to, _ = time.Parse(time.RFC3339, req.To)
+	}
+	if req.From != "" {
+		from, _ = time.Parse(time.RFC3339, req.From)
+	} else if req.To != "" {
+		from = to.Add(-defaultUnitTrackWindow)
+	}
+	limit := cmp.Or(req.Limit, maxUnitTrackPoints)
+	points, err := d.store.track(ctx, unitID, from, to, limit+1)
+	if err != nil {
+		return UnitTrack{}, err
+	}
+	result := UnitTrack{UnitID: unitID, From: from.UTC().Format(time.RFC3339), To: to.UTC().Format(time.RFC3339), Points: points}
+	if len(points) > limit {
+		result.Points, result.Truncated = points[:limit], true
+	}
+	return result, nil
+}
+
+// unitLayer places the units and the tenant's located open incidents, most severe first, linking
+// each to the nearest of the other. Incidents without a location are left off the map.
+func unitLayer(units []DispatchUnit, incidents []boardIncident, t tenant, district string, now time.Time) UnitLayer {
+	layer := UnitLayer{GeneratedAt: now.UTC().Format(time.RFC3339), Units: []UnitMarker{}, Incidents: []IncidentMarker{}}
+	for _, incident := range incidents {
+		if !t.owns(incident.OwnerOrg) || (district != "" && incident.district != district) {
+			continue
+		}
+		cell, ok := geohashBounds(incident.Geohash)
+		if !ok {
+			continue
+		}
+		layer.Incidents = append(layer.Incidents, IncidentMarker{
+			IncidentID: incident.IncidentID,
+			Severity:   incident.Severity,
+			Status:     incident.Status,
+			Category:   incident.Category,
+			District:   incident.district,
+			Latitude:   (cell.minLat + cell.maxLat) / 2,
+			Longitude:  (cell.minLng + cell.maxLng) / 2,
+		})
+	}
+	for _, unit := range units {
+		if district != "" && unitDistrict(unit.PoliceUnit) != district {
+			continue
+		}
+		marker := UnitMarker{
+			UnitID:    unit.ID,
+			Name:      unit.Name,
+			Kind:      unit.Kind,
+			Status:    unit.Status,
+			District:  unitDistrict(unit.PoliceUnit),
+			Latitude:  unit.Latitude,
+			Longitude: unit.Longitude,
+			Located:   unitLocatedStation,
+		}
+		if unit.Position != nil {
+			marker.Latitude, marker.Longitude = unit.Position.Latitude, unit.Position.Longitude
+			marker.Located, marker.ReportedAt = unitLocatedLive, unit.Position.ReportedAt
+		}
+		layer.Units = append(layer.Units, marker)
+	}
+
+	for i := range layer.Units {
+		u := &layer.Units[i]
+		for _, incident := range layer.Incidents {
+			u.NearestIncident = closerLink(u.NearestIncident, incident.IncidentID, haversineKm(u.Latitude, u.Longitude, incident.Latitude, incident.Longitude))
+		}
+	}
+	for i := range layer.Incidents {
+		incident := &layer.Incidents[i]
+		for _, u := range layer.Units {
+			km := haversineKm(incident.Latitude, incident.Longitude, u.Latitude, u.Longitude)
+			incident.NearestUnit = closerLink(incident.NearestUnit, u.UnitID, km)
+			if u.Status == "available" {
+				incident.NearestAvailable = closerLink(incident.NearestAvailable, u.UnitID, km)
+			}
+		}
+	}
+	slices.SortFunc(layer.Units, func(a, b UnitMarker) int { return strings.Compare(a.UnitID, b.UnitID) })
+	slices.SortFunc(layer.Incidents, func(a, b IncidentMarker) int {
+		rank := func(m IncidentMarker) int { return slices.Index(incidentSeverities, m.Severity) }
+		return cmp.Or(rank(b)-rank(a), strings.Compare(a.IncidentID, b.IncidentID))
+	})
+	return layer
+}
+
+// closerLink returns the link to id when it is closer than current, ties going to the lower ID
+func closerLink(current *LayerLink, id string, km float64) *LayerLink {
+	km = math.Round(km*100) / 100
+	if current != nil && (current.DistanceKm < km || (current.DistanceKm == km && current.ID < id)) {
+		return current
+	}
+	return &LayerLink{ID: id, DistanceKm: km}
+}
+
+// appendTrack keeps each unit's track in a sorted set scored by recording time, so a fix uploaded
+// twice is stored once
+func (s redisDispatchStore) appendTrack(ctx context.Context, unitID string, points []UnitPosition, cutoff time.Time) error {
+	key := unitTrackPrefix + unitID
+	args := []string{"ZADD", key}
+	for _, point := range points {
+		recorded, _ := time.Parse(time.RFC3339, point.ReportedAt)
+		data, err := json.Marshal(point)
+		if err != nil {
+			return err
+		}
+		args = append(args, strconv.FormatInt(recorded.UnixMilli(), 10), string(data))
+	}
+	if _, err := s.redis.Do(ctx, args...); err != nil {
+		return err
+	}
+	if _, err := s.redis.Do(ctx, "ZREMRANGEBYSCORE", key, "-inf", "("+strconv.FormatInt(cutoff.UnixMilli(), 10)); err != nil {
+		return err
+	}
+	_, err := s.redis.Do(ctx, "PEXPIRE", key, strconv.FormatInt(time.Since(cutoff).Milliseconds(), 10))
+	return err
+}
+
+func (s redisDispatchStore) track(ctx context.Context, unitID string, from, to time.Time, limit int) ([]UnitPosition, error) {
+	reply, err := s.redis.Do(ctx, "ZRANGEBYSCORE", unitTrackPrefix+unitID,
+		strconv.FormatInt(from.UnixMilli(), 10), strconv.FormatInt(to.UnixMilli(), 10), "LIMIT", "0", strconv.Itoa(limit))
+	if err != nil {
+		return nil, err
+	}
+	items, _ := reply.([]interface{})
+	points := make([]UnitPosition, 0, len(items))
+	for _, item := range items {
+		raw, _ := item.([]byte)
+		var point UnitPosition
+		if json.Unmarshal(raw, &point) == nil {
+			points = append(points, point)
+		}
+	}
+	return points, nil
+}
+
+func (s *memoryDispatchStore) appendTrack(_ context.Context, unitID string, points []UnitPosition, cutoff time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	oldest := cutoff.UTC().Format(time.RFC3339)
+	track := slices.DeleteFunc(append(s.unitTracks[unitID], points...), func(p UnitPosition) bool { return p.ReportedAt < oldest })
+	slices.SortStableFunc(track, func(a, b UnitPosition) int { return strings.Compare(a.ReportedAt, b.ReportedAt) })
+	s.unitTracks[unitID] = slices.CompactFunc(track, func(a, b UnitPosition) bool { return unitPositionsEqual(a, b) })
+	return nil
+}
+
+func (s *memoryDispatchStore) track(_ context.Context, unitID string, from, to time.Time, limit int) ([]UnitPosition, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	first, last := from.UTC().Format(time.RFC3339), to.UTC().Format(time.RFC3339)
+	points := []UnitPosition{}
+	for _, point := range s.unitTracks[unitID] {
+		if point.ReportedAt >= first && point.ReportedAt <= last && len(points) < limit {
+			points = append(points, point)
+		}
+	}
+	return points, nil
+}
+
+// unitPositionsEqual matches the Redis store, where a fix uploaded twice is the same set member
+func unitPositionsEqual(a, b UnitPosition) bool {
+	x, _ := json.Marshal(a)
+	y, _ := json.Marshal(b)
+	return string(x) == string(y)
+}
+
+func recordUnitFixes(c *gin.Context) {
+	if !requireDispatch(c) {
+		return
+	}
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	var req UnitFixesRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+	if policeUnitByID(id) == nil {
+		respondError(c, http.StatusNotFound, errCodeNotFound, "Unit not found")
+		return
+	}
+	setAuditTarget(c, id)
+	position, err := dispatcher.recordFixes(c.Request.Context(), id, req.Fixes, time.Now())
+	if err != nil {
+		respondServiceError(c, "Failed to record unit fixes", err)
+		return
+	}
+	respondData(c, http.StatusOK, UnitFixesResult{UnitID: id, Accepted: len(req.Fixes), Position: position})
+}
+
+func getUnitTrack(c *gin.Context) {
+	if !requireDispatch(c) {
+		return
+	}
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	var req UnitTrackRequest
+	if !bindQuery(c, &req) {
+		return
+	}
+	if policeUnitByID(id) == nil {
+		respondError(c, http.StatusNotFound, errCodeNotFound, "Unit not found")
+		return
+	}
+	track, err := dispatcher.track(c.Request.Context(), id, req, time.Now())
+	if err != nil {
+		respondServiceError(c, "Failed to read the unit track", err)
+		return
+	}
+	respondData(c, http.StatusOK, track)
+}
+
+func getUnitLayer(c *gin.Context) {
+	var req UnitLayerRequest
+	if !bindQuery(c, &req) {
+		return
+	}
+	ctx := c.Request.Context()
+	units, err := rosterUnits(ctx)
+	if err != nil {
+		respondServiceError(c, "Failed to read the unit roster", err)
+		return
+	}
+	respondData(c, http.StatusOK, unitLayer(units, liveBoard.snapshot(), tenantFromContext(ctx), req.District, time.Now()))
+}
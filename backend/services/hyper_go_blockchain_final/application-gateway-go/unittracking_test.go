@@ -0,0 +1,96 @@
+package gateway
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRecordUnitFixes(t *testing.T) {
+	ctx := context.Background()
+	d := &dispatchService{store: newMemoryDispatchStore(), trackRetention: 24 * time.Hour}
+	now := time.Date(2025, 9, 20, 15, 0, 0, 0, time.UTC)
+	fix := func(ago time.Duration, lat float64) UnitFix {
+		lng := 91.88
+		return UnitFix{Latitude: &lat, Longitude: &lng, Accuracy: 8, RecordedAt: now.Add(-ago).Format(time.RFC3339)}
+	}
+
+	position, err := d.recordFixes(ctx, "patrol-1", []UnitFix{fix(time.Minute, 25.58), fix(3*time.Minute, 25.56), fix(2*time.Minute, 25.57)}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if position.Latitude != 25.58 || position.ReportedAt != "2025-09-20T14:59:00Z" {
+		t.Fatalf("expected the newest fix live, got %+v", position)
+	}
+
+	// A late batch joins the track without moving the live position, and a repeated fix is kept once
+	position, _ = d.recordFixes(ctx, "patrol-1", []UnitFix{fix(10*time.Minute, 25.50), fix(3*time.Minute, 25.56)}, now)
+	if position.Latitude != 25.58 {
+		t.Errorf("expected the live position kept, got %+v", position)
+	}
+	track, _ := d.track(ctx, "patrol-1", UnitTrackRequest{}, now)
+	if len(track.Points) != 4 || track.Points[0].Latitude != 25.50 || track.Points[3].Latitude != 25.58 || track.Truncated {
+		t.Fatalf("expected four fixes oldest first, got %+v", track)
+	}
+	if track, _ = d.track(ctx, "patrol-1", UnitTrackRequest{Limit: 2}, now); len(track.Points) != 2 || !track.Truncated {
+		t.Errorf("expected the track truncated to two fixes, got %+v", track)
+	}
+	from := now.Add(-150 * time.Second).Format(time.RFC3339)
+	if track, _ = d.track(ctx, "patrol-1", UnitTrackRequest{From: from}, now); len(track.Points) != 2 {
+		t.Errorf("expected two fixes since %s, got %+v", from, track)
+	}
+
+	// Fixes older than the retention are dropped on the next upload
+	later := now.Add(24*time.Hour + 5*time.Minute)
+	d.recordFixes(ctx, "patrol-1", []UnitFix{fix(-24*time.Hour, 25.60)}, later)
+	if track, _ = d.track(ctx, "patrol-1", UnitTrackRequest{From: now.Add(-time.Hour).Format(time.RFC3339)}, later); len(track.Points) != 1 {
+		t.Errorf("expected only the new fix retained, got %+v", track)
+	}
+}
+
+func TestUnitFixesRequestValidation(t *testing.T) {
+	lat, lng, heading := 25.57, 91.88, 360.0
+	req := UnitFixesRequest{Fixes: []UnitFix{
+		{Latitude: &lat, Longitude: &lng, RecordedAt: time.Now().UTC().Format(time.RFC3339)},
+		{Latitude: &lat, Heading: &heading, RecordedAt: time.Now().Add(time.Hour).UTC().Format(time.RFC3339)},
+	}}
+	errs := req.Validate()
+	if len(errs) != 3 || errs[0].Field != "fixes[1].longitude" || errs[1].Field != "fixes[1].heading" || errs[2].Field != "fixes[1].recorded_at" {
+		t.Errorf("expected the second fix rejected, got %v", errs)
+	}
+}
+
+func TestUnitLayer(t *testing.T) {
+	now := time.Date(2025, 9, 20, 15, 0, 0, 0, time.UTC)
+	units := []DispatchUnit{
+		{PoliceUnit: PoliceUnit{ID: "shillong", District: "east-khasi-hills", Latitude: 25.5788, Longitude: 91.8933}, Kind: unitPolice, Status: "busy"},
+		{PoliceUnit: PoliceUnit{ID: "patrol-1", District: "east-khasi-hills", Latitude: 26.1445, Longitude: 91.7362}, Kind: unitPolice, Status: "available",
+			Position: &UnitPosition{Latitude: 25.60, Longitude: 91.90, ReportedAt: now.Add(-time.Minute).Format(time.RFC3339)}},
+		{PoliceUnit: PoliceUnit{ID: "guwahati", Latitude: 26.1445, Longitude: 91.7362}, Kind: unitPolice, Status: "available"},
+	}
+	incidents := []boardIncident{
+		{IncidentDocument: IncidentDocument{IncidentID: "INC-1", Severity: "high", Geohash: encodeGeohash(25.57, 91.89, ledgerGeohashPrecision), OwnerOrg: "Org1MSP"}, district: "east-khasi-hills"},
+		{IncidentDocument: IncidentDocument{IncidentID: "INC-2", Severity: "critical", Geohash: encodeGeohash(26.15, 91.74, ledgerGeohashPrecision), OwnerOrg: "Org1MSP"}, district: "kamrup-metro"},
+		{IncidentDocument: IncidentDocument{IncidentID: "INC-3", Severity: "critical", OwnerOrg: "Org1MSP"}, district: unassignedDistrict},
+		{IncidentDocument: IncidentDocument{IncidentID: "INC-4", Severity: "critical", Geohash: encodeGeohash(25.57, 91.89, ledgerGeohashPrecision), OwnerOrg: "Org2MSP"}, district: "east-khasi-hills"},
+	}
+
+	layer := unitLayer(units, incidents, tenant{org: "Org1MSP"}, "", now)
+	if len(layer.Incidents) != 2 || layer.Incidents[0].IncidentID != "INC-2" || layer.Incidents[1].IncidentID != "INC-1" {
+		t.Fatalf("expected the tenant's located incidents, most severe first, got %+v", layer.Incidents)
+	}
+	if inc := layer.Incidents[1]; inc.NearestUnit.ID != "shillong" || inc.NearestAvailable.ID != "patrol-1" || inc.NearestAvailable.DistanceKm > 5 {
+		t.Errorf("expected Shillong nearest and the patrol nearest available, got %+v %+v", inc.NearestUnit, inc.NearestAvailable)
+	}
+	if len(layer.Units) != 3 || layer.Units[1].UnitID != "patrol-1" || layer.Units[1].Located != unitLocatedLive || layer.Units[1].NearestIncident.ID != "INC-1" {
+		t.Fatalf("expected the patrol at its live position near INC-1, got %+v", layer.Units)
+	}
+	if u := layer.Units[0]; u.UnitID != "guwahati" || u.Located != unitLocatedStation || u.District != unassignedDistrict || u.NearestIncident.ID != "INC-2" {
+		t.Errorf("expected Guwahati at its station near INC-2, got %+v", u)
+	}
+
+	only := unitLayer(units, incidents, tenant{all: true}, "east-khasi-hills", now)
+	if len(only.Units) != 2 || len(only.Incidents) != 2 || only.Incidents[0].IncidentID != "INC-4" {
+		t.Errorf("expected the district's units and incidents, got %+v", only)
+	}
+}
@@ -0,0 +1,424 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+)
+
+var (
+	sha256Regex      = regexp.MustCompile(`^[a-f0-9]{64}$`)
+	identifierRegex  = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._:-]{0,127}$`)
+	digitalIDRegex   = regexp.MustCompile(`^did:[a-z0-9]+:[A-Za-z0-9._:-]{1,128}$`)
+	auditActionRegex = regexp.MustCompile(`^[A-Z][A-Z_]{0,63}$`)
+	fieldNameRegex   = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+)
+
+// allowedMediaTypes lists the evidence formats the chaincode accepts
+var allowedMediaTypes = []string{
+	"image/jpeg",
+	"image/png",
+	"image/heic",
+	"video/mp4",
+	"video/quicktime",
+	"audio/mpeg",
+	"audio/mp4",
+	"audio/wav",
+	"application/pdf",
+	"text/plain",
+}
+
+// Incident lifecycle states and severities, matching the chaincode
+var (
+	incidentStatuses   = []string{"open", "acknowledged", "resolved", "closed"}
+	incidentSeverities = []string{"low", "medium", "high", "critical"}
+	incidentCategories = []string{"missing_person", "medical", "accident", "theft", "harassment", "anomaly", "geofence", "other"}
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// FieldError describes why a single request field was rejected
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+
+	// format and args let the message be rendered again in the caller's language
+	format string
+	args   []interface{}
+}
+
+// ValidationErrors collects every field error found in a request
+type ValidationErrors []FieldError
+
+func (v ValidationErrors) Error() string {
+	messages := make([]string, 0, len(v))
+	for _, fe := range v {
+		messages = append(messages, fe.Field+": "+fe.Message)
+	}
+	return strings.Join(messages, "; ")
+}
+
+// validatable is implemented by request bodies that carry checks beyond gin's binding tags
+type validatable interface {
+	Validate() ValidationErrors
+}
+
+// fieldValidator accumulates field errors so a client sees all problems in one response
+type fieldValidator struct {
+	errors ValidationErrors
+}
+
+func (v *fieldValidator) add(field, format string, args ...interface{}) {
+	v.errors = append(v.errors, FieldError{Field: field, Message: fmt.Sprintf(format, args...), format: format, args: args})
+}
+
+func (v *fieldValidator) sha256(field, value string) {
+	if !sha256Regex.MatchString(value) {
+		v.add(field, "must be a valid SHA-256 hash (64 lowercase hex characters)")
+	}
+}
+
+func (v *fieldValidator) rfc3339(field, value string) (time.Time, bool) {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		v.add(field, "must be an RFC3339 timestamp such as 2025-12-31T23:59:59Z")
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// timeRange checks optional from/to query bounds
+func (v *fieldValidator) timeRange(from, to string) {
+	var start, end time.Time
+	fromOK, toOK := false, false
+	if from != "" {
+		start, fromOK = v.rfc3339("from", from)
+	}
+	if to != "" {
+		end, toOK = v.rfc3339("to", to)
+	}
+	if fromOK && toOK && !start.Before(end) {
+		v.add("to", "must be after from")
+	}
+}
+
+func (v *fieldValidator) pageSize(size int) {
+	if size < 0 || size > maxPageSize {
+		v.add("page_size", "must be between 1 and %d", maxPageSize)
+	}
+}
+
+func (v *fieldValidator) future(field, value string) {
+	if t, ok := v.rfc3339(field, value); ok && !t.After(time.Now()) {
+		v.add(field, "must be in the future")
+	}
+}
+
+func (v *fieldValidator) identifier(field, value string) {
+	if !identifierRegex.MatchString(value) {
+		v.add(field, "must be 1-128 characters of letters, digits, '.', '_', ':' or '-'")
+	}
+}
+
+func (v *fieldValidator) digitalID(field, value string) {
+	if !digitalIDRegex.MatchString(value) {
+		v.add(field, "must be a DID of the form did:<method>:<identifier>")
+	}
+}
+
+func (v *fieldValidator) oneOf(field, value string, allowed []string) {
+	for _, candidate := range allowed {
+		if value == candidate {
+			return
+		}
+	}
+	v.add(field, "must be one of %s", strings.Join(allowed, ", "))
+}
+
+func (r CreateDIDRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.digitalID("digitalID", r.DigitalID)
+	v.sha256("consentHash", r.ConsentHash)
+	v.future("expiresAt", r.ExpiresAt)
+	v.identifier("issuer", r.Issuer)
+	return v.errors
+}
+
+func (r UpdateDIDRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.sha256("consentHash", r.ConsentHash)
+	v.future("expiresAt", r.ExpiresAt)
+	v.identifier("updater", r.Updater)
+	return v.errors
+}
+
+func (r DeleteRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.identifier("actor", r.Actor)
+	return v.errors
+}
+
+func (r CreateIncidentRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.identifier("incidentID", r.IncidentID)
+	v.sha256("incidentSummaryHash", r.IncidentSummaryHash)
+	v.identifier("reporter", r.Reporter)
+	if r.Severity != "" {
+		v.oneOf("severity", r.Severity, incidentSeverities)
+	}
+	if r.Category != "" {
+		v.oneOf("category", r.Category, incidentCategories)
+	}
+	if r.DigitalID != "" {
+		v.digitalID("digitalID", r.DigitalID)
+		if r.Category == "" {
+			v.add("category", "is required when digitalID is set")
+		}
+	}
+	switch {
+	case (r.Latitude == nil) != (r.Longitude == nil):
+		v.add("latitude", "must be given together with longitude")
+	case r.Latitude != nil:
+		if *r.Latitude < -90 || *r.Latitude > 90 {
+			v.add("latitude", "must be between -90 and 90")
+		}
+		if *r.Longitude < -180 || *r.Longitude > 180 {
+			v.add("longitude", "must be between -180 and 180")
+		}
+	}
+	return v.errors
+}
+
+func (r IncidentSearchRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.timeRange(r.From, r.To)
+	if r.Status != "" {
+		v.oneOf("status", r.Status, incidentStatuses)
+	}
+	if r.Severity != "" {
+		v.oneOf("severity", r.Severity, incidentSeverities)
+	}
+	if r.Reporter != "" {
+		v.identifier("reporter", r.Reporter)
+	}
+	v.pageSize(r.PageSize)
+	return v.errors
+}
+
+func (r UpdateIncidentRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.sha256("incidentSummaryHash", r.IncidentSummaryHash)
+	v.identifier("updater", r.Updater)
+	return v.errors
+}
+
+func (r UpdateIncidentStatusRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.oneOf("status", r.Status, incidentStatuses[1:])
+	v.identifier("updater", r.Updater)
+	return v.errors
+}
+
+func (r MergeIncidentsRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	if len(r.DuplicateIDs) == 0 || len(r.DuplicateIDs) > maxMergedIncidents {
+		v.add("duplicateIDs", "must list between 1 and %d incidents", maxMergedIncidents)
+	}
+	seen := map[string]bool{}
+	for i, id := range r.DuplicateIDs {
+		field := fmt.Sprintf("duplicateIDs[%d]", i)
+		v.identifier(field, id)
+		if seen[id] {
+			v.add(field, "is listed more than once")
+		}
+		seen[id] = true
+	}
+	v.identifier("actor", r.Actor)
+	return v.errors
+}
+
+func (r CreateEvidenceRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.identifier("evidenceID", r.EvidenceID)
+	v.sha256("evidenceHash", r.EvidenceHash)
+	v.identifier("incidentID", r.IncidentID)
+	v.oneOf("mediaType", r.MediaType, allowedMediaTypes)
+	v.identifier("uploadedBy", r.UploadedBy)
+	return v.errors
+}
+
+func (r AuditSearchRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	if r.Actor != "" {
+		v.identifier("actor", r.Actor)
+	}
+	if r.Action != "" && !auditActionRegex.MatchString(r.Action) {
+		v.add("action", "must be an upper-case action name such as UPDATE_INCIDENT")
+	}
+	v.timeRange(r.From, r.To)
+	v.pageSize(r.PageSize)
+	return v.errors
+}
+
+func (r IncidentExportRequest) Validate() ValidationErrors {
+	errs := IncidentSearchRequest{From: r.From, To: r.To, Status: r.Status, Severity: r.Severity, Reporter: r.Reporter}.Validate()
+	return append(errs, exportFormatErrors(r.Format)...)
+}
+
+func (r AuditExportRequest) Validate() ValidationErrors {
+	errs := AuditSearchRequest{Actor: r.Actor, Action: r.Action, From: r.From, To: r.To}.Validate()
+	return append(errs, exportFormatErrors(r.Format)...)
+}
+
+func (r DocumentExportRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	if r.Include != "" && r.Exclude != "" {
+		v.add("exclude", "cannot be combined with include")
+	}
+	for field, list := range map[string]string{"include": r.Include, "exclude": r.Exclude} {
+		names := splitList(list)
+		if len(names) > maxProjectedFields {
+			v.add(field, "must list at most %d fields", maxProjectedFields)
+		}
+		for _, name := range names {
+			if !fieldNameRegex.MatchString(name) {
+				v.add(field, "must list snake_case field names such as incident_id")
+				break
+			}
+		}
+	}
+	return v.errors
+}
+
+func exportFormatErrors(format string) ValidationErrors {
+	var v fieldValidator
+	if format != "" {
+		v.oneOf("format", format, exportFormats)
+	}
+	return v.errors
+}
+
+// evidenceSorts are the orders the chaincode and the off-chain index support
+var evidenceSorts = []string{"created_at", "-created_at", "media_type", "-media_type", "uploaded_by", "-uploaded_by"}
+
+func (r EvidenceListRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	if r.MediaType != "" {
+		v.oneOf("media_type", r.MediaType, allowedMediaTypes)
+	}
+	if r.UploadedBy != "" {
+		v.identifier("uploader", r.UploadedBy)
+	}
+	v.timeRange(r.From, r.To)
+	if r.Sort != "" {
+		v.oneOf("sort", r.Sort, evidenceSorts)
+	}
+	return v.errors
+}
+
+func (r UpdateEvidenceRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.sha256("evidenceHash", r.EvidenceHash)
+	v.oneOf("mediaType", r.MediaType, allowedMediaTypes)
+	v.identifier("updater", r.Updater)
+	return v.errors
+}
+
+// bindRequest decodes and validates a JSON body, writing a 400 with field-level errors on failure
+func bindRequest(c *gin.Context, req interface{}) bool {
+	if err := c.ShouldBindJSON(req); err != nil {
+		respondValidationErrors(c, bindingErrors(err))
+		return false
+	}
+
+	if v, ok := req.(validatable); ok {
+		if errs := v.Validate(); len(errs) > 0 {
+			respondValidationErrors(c, errs)
+			return false
+		}
+	}
+	return true
+}
+
+// bindQuery decodes and validates query parameters, writing a 400 with field-level errors on failure
+func bindQuery(c *gin.Context, req validatable) bool {
+	if err := c.ShouldBindQuery(req); err != nil {
+		var validationErrs validator.ValidationErrors
+		if errors.As(err, &validationErrs) {
+			respondValidationErrors(c, bindingErrors(err))
+		} else {
+			respondValidationErrors(c, ValidationErrors{{Field: "query", Message: err.Error()}})
+		}
+		return false
+	}
+
+	if errs := req.Validate(); len(errs) > 0 {
+		respondValidationErrors(c, errs)
+		return false
+	}
+	return true
+}
+
+// validPathID checks a path parameter against the identifier rules before it reaches the chaincode
+func validPathID(c *gin.Context, param string) (string, bool) {
+	id := c.Param(param)
+	if errs := validateDocumentID(param, id); len(errs) > 0 {
+		respondValidationErrors(c, errs)
+		return "", false
+	}
+	return id, true
+}
+
+// validateDocumentID checks a ledger key, which is either a DID or a plain identifier
+func validateDocumentID(field, id string) ValidationErrors {
+	var v fieldValidator
+	if strings.HasPrefix(id, "did:") {
+		v.digitalID(field, id)
+	} else {
+		v.identifier(field, id)
+	}
+	return v.errors
+}
+
+// bindingErrors converts gin binding failures into the same field error shape as Validate
+func bindingErrors(err error) ValidationErrors {
+	var validationErrs validator.ValidationErrors
+	if !errors.As(err, &validationErrs) {
+		return ValidationErrors{{Field: "body", Message: err.Error()}}
+	}
+
+	result := make(ValidationErrors, 0, len(validationErrs))
+	for _, fe := range validationErrs {
+		field := FieldError{Field: lowerFirst(fe.Field()), Message: "is required"}
+		if fe.Tag() != "required" {
+			field.format, field.args = "failed on the '%s' rule", []interface{}{fe.Tag()}
+			field.Message = fmt.Sprintf(field.format, field.args...)
+		}
+		result = append(result, field)
+	}
+	return result
+}
+
+func lowerFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	runes := []rune(s)
+	runes[0] = unicode.ToLower(runes[0])
+	return string(runes)
+}
@@ -0,0 +1,167 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const validHash = "a1b2c3d4e5f6789012345678901234567890123456789012345678901234abcd"
+
+func TestCreateDIDRequestValidate(t *testing.T) {
+	future := time.Now().AddDate(1, 0, 0).UTC().Format(time.RFC3339)
+
+	valid := CreateDIDRequest{DigitalID: "did:sih:tourist123", ConsentHash: validHash, ExpiresAt: future, Issuer: "tourism_authority"}
+	if errs := valid.Validate(); len(errs) != 0 {
+		t.Fatalf("expected no errors, got %v", errs)
+	}
+
+	invalid := CreateDIDRequest{DigitalID: "tourist123", ConsentHash: "not-a-hash", ExpiresAt: "2025-12-31", Issuer: "bad issuer"}
+	errs := invalid.Validate()
+	fields := map[string]bool{}
+	for _, fe := range errs {
+		fields[fe.Field] = true
+	}
+	for _, field := range []string{"digitalID", "consentHash", "expiresAt", "issuer"} {
+		if !fields[field] {
+			t.Errorf("expected an error for %s, got %v", field, errs)
+		}
+	}
+}
+
+func TestCreateDIDRequestValidate_ExpiredTimestamp(t *testing.T) {
+	past := time.Now().AddDate(-1, 0, 0).UTC().Format(time.RFC3339)
+	req := CreateDIDRequest{DigitalID: "did:sih:tourist123", ConsentHash: validHash, ExpiresAt: past, Issuer: "issuer"}
+
+	errs := req.Validate()
+	if len(errs) != 1 || errs[0].Field != "expiresAt" || errs[0].Message != "must be in the future" {
+		t.Fatalf("expected expiresAt future error, got %v", errs)
+	}
+}
+
+func TestCreateEvidenceRequestValidate_MediaType(t *testing.T) {
+	req := CreateEvidenceRequest{EvidenceID: "ev_001", EvidenceHash: validHash, IncidentID: "inc_001", MediaType: "application/x-msdownload", UploadedBy: "user_1"}
+
+	errs := req.Validate()
+	if len(errs) != 1 || errs[0].Field != "mediaType" {
+		t.Fatalf("expected mediaType error, got %v", errs)
+	}
+
+	req.MediaType = "image/jpeg"
+	if errs := req.Validate(); len(errs) != 0 {
+		t.Fatalf("expected no errors, got %v", errs)
+	}
+}
+
+func TestBindRequest_FieldErrors(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantField  string
+	}{
+		{"missing required field", `{"incidentSummaryHash":"` + validHash + `","reporter":"app"}`, http.StatusBadRequest, `"field":"incidentID"`},
+		{"malformed json", `{"incidentID":`, http.StatusBadRequest, `"field":"body"`},
+		{"invalid hash", `{"incidentID":"inc_1","incidentSummaryHash":"abc","reporter":"app"}`, http.StatusBadRequest, `"field":"incidentSummaryHash"`},
+		{"valid", `{"incidentID":"inc_1","incidentSummaryHash":"` + validHash + `","reporter":"app"}`, http.StatusOK, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodPost, "/api/v1/incident/", strings.NewReader(tt.body))
+			c.Request.Header.Set("Content-Type", "application/json")
+
+			var req CreateIncidentRequest
+			if bindRequest(c, &req) {
+				c.Status(http.StatusOK)
+			}
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d (%s)", tt.wantStatus, w.Code, w.Body.String())
+			}
+			if tt.wantField != "" && !strings.Contains(w.Body.String(), tt.wantField) {
+				t.Errorf("expected body to contain %s, got %s", tt.wantField, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestIncidentSearchRequestValidate(t *testing.T) {
+	valid := IncidentSearchRequest{From: "2025-01-01T00:00:00Z", To: "2025-02-01T00:00:00+05:30", Status: "open", Severity: "high", Reporter: "officer-7"}
+	if errs := valid.Validate(); len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if errs := (IncidentSearchRequest{}).Validate(); len(errs) != 0 {
+		t.Fatalf("empty search should be valid: %v", errs)
+	}
+
+	invalid := IncidentSearchRequest{From: "2025-02-01T00:00:00Z", To: "2025-01-01T00:00:00Z", Status: "pending", Severity: "urgent", PageSize: 500}
+	fields := map[string]bool{}
+	for _, fe := range invalid.Validate() {
+		fields[fe.Field] = true
+	}
+	for _, field := range []string{"to", "status", "severity", "page_size"} {
+		if !fields[field] {
+			t.Errorf("expected an error for %s", field)
+		}
+	}
+}
+
+func TestAuditSearchRequestValidate(t *testing.T) {
+	valid := AuditSearchRequest{Actor: "officer-7", Action: "UPDATE_INCIDENT", From: "2025-01-01T00:00:00Z", To: "2025-02-01T00:00:00Z", PageSize: 50}
+	if errs := valid.Validate(); len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	invalid := AuditSearchRequest{Actor: "bad actor", Action: "update", From: "yesterday"}
+	fields := map[string]bool{}
+	for _, fe := range invalid.Validate() {
+		fields[fe.Field] = true
+	}
+	for _, field := range []string{"actor", "action", "from"} {
+		if !fields[field] {
+			t.Errorf("expected an error for %s", field)
+		}
+	}
+}
+
+func TestEvidenceListRequestValidate(t *testing.T) {
+	valid := EvidenceListRequest{MediaType: "image/jpeg", UploadedBy: "officer-7", From: "2025-01-01T00:00:00Z", Sort: "-created_at"}
+	if errs := valid.Validate(); len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	invalid := EvidenceListRequest{MediaType: "image/gif", UploadedBy: "bad uploader", To: "tomorrow", Sort: "size"}
+	fields := map[string]bool{}
+	for _, fe := range invalid.Validate() {
+		fields[fe.Field] = true
+	}
+	for _, field := range []string{"media_type", "uploader", "to", "sort"} {
+		if !fields[field] {
+			t.Errorf("expected an error for %s", field)
+		}
+	}
+	for _, sort := range evidenceSorts {
+		if evidenceOrders[sort] == "" {
+			t.Errorf("the off-chain index has no order for %s", sort)
+		}
+	}
+}
+
+func TestUpdateIncidentStatusRequestValidate(t *testing.T) {
+	if errs := (UpdateIncidentStatusRequest{Status: "acknowledged", Updater: "officer-7"}).Validate(); len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	// Incidents start open, so it is not a state they can be moved to
+	if errs := (UpdateIncidentStatusRequest{Status: "open", Updater: "officer-7"}).Validate(); len(errs) != 1 || errs[0].Field != "status" {
+		t.Fatalf("expected a status error, got %v", errs)
+	}
+}
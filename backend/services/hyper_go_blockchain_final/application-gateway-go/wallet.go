@@ -0,0 +1,448 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"github.com/hyperledger/fabric-gateway/pkg/identity"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	errCodeUnauthenticated = "UNAUTHENTICATED"
+	walletFileSuffix       = ".id"
+)
+
+var errUnknownCaller = errors.New("API key is not registered with the wallet")
+
+// walletIdentity is an enrolled identity the gateway can sign transactions as
+type walletIdentity struct {
+	Label string `json:"label"`
+	MSPID string `json:"msp_id"`
+
+	gateway *client.Gateway
+}
+
+// walletFile is the identity file format written by the Fabric SDK filesystem wallets. Identities
+// of type HSM-X.509 hold only the certificate; their key stays on a PKCS#11 token. External-X.509
+// identities also hold only the certificate, and sign through the offline endpoints.
+type walletFile struct {
+	Version     int    `json:"version"`
+	MSPID       string `json:"mspId"`
+	Type        string `json:"type"`
+	Credentials struct {
+		Certificate string `json:"certificate"`
+		PrivateKey  string `json:"privateKey,omitempty"`
+	} `json:"credentials"`
+	HSM *hsmConfig `json:"hsm,omitempty"`
+}
+
+// wallet maps authenticated API callers to the identities their transactions are signed with.
+// Provisioning adds and removes identities while requests are served, so the maps are guarded.
+type wallet struct {
+	dir         string
+	callersFile string
+
+	mu         sync.RWMutex
+	identities map[string]*walletIdentity
+	// callers maps the hex SHA-256 of an API key to an identity label
+	callers       map[string]string
+	requireCaller bool
+}
+
+var callerWallet *wallet
+
+type signerContextKey struct{}
+
+// initWallet loads every identity in WALLET_PATH and the API key mapping in WALLET_CALLERS_FILE.
+// Without WALLET_PATH all transactions are signed as the gateway identity, as before.
+func initWallet() {
+	dir := getEnv("WALLET_PATH", "")
+	if dir == "" {
+		log.Println("👛 WALLET_PATH not set, signing every transaction as the gateway identity")
+		return
+	}
+
+	files, err := readWalletDir(dir)
+	if err != nil {
+		panic(fmt.Errorf("failed to load wallet: %w", err))
+	}
+	callersFile := getEnv("WALLET_CALLERS_FILE", filepath.Join(dir, "callers.json"))
+	callers, err := readWalletCallers(callersFile)
+	if err != nil {
+		panic(fmt.Errorf("failed to load wallet callers: %w", err))
+	}
+
+	w := &wallet{dir: dir, callersFile: callersFile, identities: map[string]*walletIdentity{}, callers: callers, requireCaller: getEnvBool("WALLET_REQUIRE_CALLER", false)}
+	for label, file := range files {
+		id, sign, err := file.credentials()
+		if err != nil {
+			panic(fmt.Errorf("failed to load wallet identity %s: %w", label, err))
+		}
+		gw, err := connectGateway(id, sign)
+		if err != nil {
+			panic(fmt.Errorf("failed to connect wallet identity %s: %w", label, err))
+		}
+		w.identities[label] = &walletIdentity{Label: label, MSPID: file.MSPID, gateway: gw}
+	}
+	for hash, label := range callers {
+		if _, ok := w.identities[label]; !ok {
+			panic(fmt.Errorf("wallet caller %s… maps to unknown identity %q", hash[:8], label))
+		}
+	}
+
+	callerWallet = w
+	log.Printf("👛 Loaded %d wallet identities for %d API callers from %s", len(w.identities), len(callers), dir)
+}
+
+// readWalletDir parses every identity file in dir, keyed by label (the file name without .id)
+func readWalletDir(dir string) (map[string]walletFile, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	files := map[string]walletFile{}
+	for _, entry := range entries {
+		label, ok := strings.CutSuffix(entry.Name(), walletFileSuffix)
+		if entry.IsDir() || !ok {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var file walletFile
+		if err := json.Unmarshal(data, &file); err != nil {
+			return nil, fmt.Errorf("%s: %w", entry.Name(), err)
+		}
+		files[label] = file
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no %s identity files in %s", walletFileSuffix, dir)
+	}
+	return files, nil
+}
+
+// readWalletCallers reads a JSON object mapping the hex SHA-256 of each API key to an identity
+// label. Only hashes are stored so the file does not hold usable credentials.
+func readWalletCallers(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var callers map[string]string
+	if err := json.Unmarshal(data, &callers); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	normalized := make(map[string]string, len(callers))
+	for hash, label := range callers {
+		hash = strings.ToLower(hash)
+		if decoded, err := hex.DecodeString(hash); err != nil || len(decoded) != sha256.Size {
+			return nil, fmt.Errorf("%s: %q is not a SHA-256 hex digest", path, hash)
+		}
+		normalized[hash] = label
+	}
+	return normalized, nil
+}
+
+// credentials builds the signing identity from an X.509, HSM-X.509 or External-X.509 wallet entry
+func (f walletFile) credentials() (*identity.X509Identity, identity.Sign, error) {
+	if f.Type != "" && f.Type != "X.509" && f.Type != "HSM-X.509" && f.Type != "External-X.509" {
+		return nil, nil, fmt.Errorf("unsupported identity type %q", f.Type)
+	}
+	certificate, err := identity.CertificateFromPEM([]byte(f.Credentials.Certificate))
+	if err != nil {
+		return nil, nil, err
+	}
+	id, err := identity.NewX509Identity(f.MSPID, certificate)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if f.Type == "External-X.509" {
+		return id, func([]byte) ([]byte, error) { return nil, errExternalSigner }, nil
+	}
+	if f.Type == "HSM-X.509" {
+		var cfg hsmConfig
+		if f.HSM != nil {
+			cfg = *f.HSM
+		}
+		opts, err := cfg.options(certificate)
+		if err != nil {
+			return nil, nil, err
+		}
+		sign, err := newHSMSign(opts)
+		if err != nil {
+			return nil, nil, err
+		}
+		return id, sign, nil
+	}
+
+	privateKey, err := identity.PrivateKeyFromPEM([]byte(f.Credentials.PrivateKey))
+	if err != nil {
+		return nil, nil, err
+	}
+	sign, err := identity.NewPrivateKeySign(privateKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return id, sign, nil
+}
+
+// resolve returns the identity for an API key. An empty key selects the gateway identity (nil)
+// unless callers are required to authenticate.
+func (w *wallet) resolve(apiKey string) (*walletIdentity, error) {
+	if apiKey == "" {
+		if w.requireCaller {
+			return nil, errUnknownCaller
+		}
+		return nil, nil
+	}
+
+	digest := hashToken(apiKey)
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	for hash, label := range w.callers {
+		if subtle.ConstantTimeCompare([]byte(hash), []byte(digest)) == 1 {
+			return w.identities[label], nil
+		}
+	}
+	return nil, errUnknownCaller
+}
+
+// identity returns the loaded identity with label, or nil
+func (w *wallet) identity(label string) *walletIdentity {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.identities[label]
+}
+
+// labels lists the loaded identities in name order
+func (w *wallet) labels() []string {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	labels := make([]string, 0, len(w.identities))
+	for label := range w.identities {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	return labels
+}
+
+func (w *wallet) close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, id := range w.identities {
+		id.gateway.Close()
+	}
+}
+
+// add writes an identity file to the wallet directory, connects it and maps the API key hash to
+// it in the callers file. Nothing is kept if any step fails.
+func (w *wallet) add(label string, file walletFile, keyHash string) (*walletIdentity, error) {
+	id, sign, err := file.credentials()
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.identities[label]; ok {
+		return nil, fmt.Errorf("wallet identity %s already exists", label)
+	}
+	callers := maps.Clone(w.callers)
+	callers[keyHash] = label
+	path := filepath.Join(w.dir, label+walletFileSuffix)
+	if err := writeFileAtomic(path, data); err != nil {
+		return nil, err
+	}
+	if err := writeWalletCallers(w.callersFile, callers); err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	gw, err := connectGateway(id, sign)
+	if err != nil {
+		os.Remove(path)
+		writeWalletCallers(w.callersFile, w.callers)
+		return nil, err
+	}
+	added := &walletIdentity{Label: label, MSPID: file.MSPID, gateway: gw}
+	w.identities[label], w.callers = added, callers
+	return added, nil
+}
+
+// remove drops an identity, its file and every API key mapped to it
+func (w *wallet) remove(label string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	id, ok := w.identities[label]
+	if !ok {
+		return fmt.Errorf("wallet identity %s does not exist", label)
+	}
+	callers := maps.Clone(w.callers)
+	maps.DeleteFunc(callers, func(_, mapped string) bool { return mapped == label })
+	if err := writeWalletCallers(w.callersFile, callers); err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(w.dir, label+walletFileSuffix)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	delete(w.identities, label)
+	w.callers = callers
+	id.gateway.Close()
+	return nil
+}
+
+// writeWalletCallers saves the API key mapping in the layout readWalletCallers reads
+func writeWalletCallers(path string, callers map[string]string) error {
+	data, err := json.MarshalIndent(callers, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+// writeFileAtomic replaces path with data through a temporary file in the same directory, so a
+// crash never leaves it half written
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// withSigner signs Fabric calls made with the returned context as id
+func withSigner(ctx context.Context, id *walletIdentity) context.Context {
+	return context.WithValue(ctx, signerContextKey{}, id)
+}
+
+// gatewayFromContext returns the gateway connection for the request's signing identity
+func gatewayFromContext(ctx context.Context) *client.Gateway {
+	if id, ok := ctx.Value(signerContextKey{}).(*walletIdentity); ok && id != nil {
+		return id.gateway
+	}
+	return gateway
+}
+
+// signerMiddleware signs the request's transactions as the wallet identity registered for its
+// X-API-Key, session access token or client certificate. Unknown keys are rejected rather than
+// falling back to the gateway identity.
+func signerMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := authenticateRequest(c); err != nil {
+			code := errCodeUnauthenticated
+			var authErr *authError
+			if errors.As(err, &authErr) {
+				code = authErr.code
+			}
+			respondError(c, http.StatusUnauthorized, code, err.Error())
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// authenticatedKey holds the outcome of authenticating a request in its gin context
+const authenticatedKey = "authenticated"
+
+// authenticateRequest checks the request's credentials once, so the rate limiter can key on the
+// identity before signerMiddleware rejects or signs with it. On success the request's context
+// carries the identity.
+func authenticateRequest(c *gin.Context) error {
+	if outcome, ok := c.Get(authenticatedKey); ok {
+		err, _ := outcome.(error)
+		return err
+	}
+	if callerWallet == nil && clientCertRules.Load() == nil {
+		c.Set(authenticatedKey, nil)
+		return nil
+	}
+	var cert *x509.Certificate
+	if c.Request.TLS != nil {
+		cert = verifiedClientCert(c.Request.TLS.VerifiedChains)
+	}
+	ctx, err := authenticateCaller(c.Request.Context(), c.GetHeader(apiKeyHeader), c.GetHeader("Authorization"), cert, c.FullPath() == tokenExchangeRoute)
+	if err == nil {
+		c.Request = c.Request.WithContext(ctx)
+	}
+	c.Set(authenticatedKey, err)
+	return err
+}
+
+// grpcSignerInterceptor selects the signing identity from the x-api-key or authorization metadata
+func grpcSignerInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := grpcSignerContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// grpcSignerStreamInterceptor authenticates a stream once, when it is opened
+func grpcSignerStreamInterceptor(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := grpcSignerContext(stream.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srv, contextStream{stream, ctx})
+}
+
+func grpcSignerContext(ctx context.Context) (context.Context, error) {
+	if callerWallet == nil && clientCertRules.Load() == nil {
+		return ctx, nil
+	}
+	var apiKey, authorization string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(apiKeyHeader); len(values) > 0 {
+			apiKey = values[0]
+		}
+		if values := md.Get("authorization"); len(values) > 0 {
+			authorization = values[0]
+		}
+	}
+	ctx, err := authenticateCaller(ctx, apiKey, authorization, grpcClientCert(ctx), false)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	return ctx, nil
+}
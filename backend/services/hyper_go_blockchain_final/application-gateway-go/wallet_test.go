@@ -0,0 +1,133 @@
+package gateway
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func writeWalletIdentity(t *testing.T, dir, label string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: label},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var file walletFile
+	file.Version, file.MSPID, file.Type = 1, "Org1MSP", "X.509"
+	file.Credentials.Certificate = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	file.Credentials.PrivateKey = string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}))
+	data, _ := json.Marshal(file)
+	if err := os.WriteFile(filepath.Join(dir, label+walletFileSuffix), data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadWalletDir(t *testing.T) {
+	dir := t.TempDir()
+	writeWalletIdentity(t, dir, "officer-1")
+	os.WriteFile(filepath.Join(dir, "README"), []byte("not an identity"), 0o600)
+
+	files, err := readWalletDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, ok := files["officer-1"]
+	if len(files) != 1 || !ok {
+		t.Fatalf("unexpected wallet contents %v", files)
+	}
+	id, sign, err := file.credentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.MspID() != "Org1MSP" || sign == nil {
+		t.Errorf("unexpected identity %s", id.MspID())
+	}
+	if _, err := sign(make([]byte, 32)); err != nil {
+		t.Errorf("sign failed: %v", err)
+	}
+}
+
+func TestReadWalletCallers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "callers.json")
+	sum := sha256.Sum256([]byte("secret"))
+	os.WriteFile(path, []byte(`{"`+hex.EncodeToString(sum[:])+`": "officer-1"}`), 0o600)
+	callers, err := readWalletCallers(path)
+	if err != nil || callers[hex.EncodeToString(sum[:])] != "officer-1" {
+		t.Fatalf("unexpected callers %v (%v)", callers, err)
+	}
+
+	os.WriteFile(path, []byte(`{"secret": "officer-1"}`), 0o600)
+	if _, err := readWalletCallers(path); err == nil {
+		t.Error("expected a raw API key to be rejected")
+	}
+}
+
+func TestWalletResolve(t *testing.T) {
+	sum := sha256.Sum256([]byte("secret"))
+	officer := &walletIdentity{Label: "officer-1"}
+	w := &wallet{identities: map[string]*walletIdentity{"officer-1": officer}, callers: map[string]string{hex.EncodeToString(sum[:]): "officer-1"}}
+
+	if id, err := w.resolve("secret"); err != nil || id != officer {
+		t.Errorf("expected officer-1, got %v (%v)", id, err)
+	}
+	if id, err := w.resolve(""); err != nil || id != nil {
+		t.Errorf("expected the gateway identity for anonymous callers, got %v (%v)", id, err)
+	}
+	if _, err := w.resolve("guess"); !errors.Is(err, errUnknownCaller) {
+		t.Errorf("expected an unknown key to be rejected, got %v", err)
+	}
+
+	w.requireCaller = true
+	if _, err := w.resolve(""); !errors.Is(err, errUnknownCaller) {
+		t.Errorf("expected anonymous callers to be rejected, got %v", err)
+	}
+}
+
+func TestSignerMiddlewareRejectsUnknownKey(t *testing.T) {
+	previous := callerWallet
+	callerWallet = &wallet{identities: map[string]*walletIdentity{}, callers: map[string]string{}}
+	t.Cleanup(func() { callerWallet = previous })
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/", signerMiddleware(), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(apiKeyHeader, "guess")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401, got %d", w.Code)
+	}
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"fmt"
+)
+
+// wearableAdapter onboards one vendor's bands or panic buttons. The ingestion core looks the
+// adapter up by the device type a band was registered with and knows nothing else about the vendor.
+// A new vendor is added in a file of its own that registers its adapter from an init function.
+type wearableAdapter interface {
+	// verify unwraps a message and checks it against the band's registered attestation key,
+	// returning the payload and whether the band signed it
+	verify(device DeviceDocument, message []byte) (payload []byte, attested bool, err error)
+	// decode normalizes a verified payload, setting SOS when the vendor reports an alarm. Timestamps
+	// left empty are filled with the time the message arrived.
+	decode(payload []byte) (WearableTelemetry, error)
+	// sos maps a decoded reading to the SOS it raises, nil when it raises none
+	sos(device DeviceDocument, t WearableTelemetry) *wearableSOS
+}
+
+// wearableSOS is the SOS alert a reading raises. Confirmed skips the confirmation window, for
+// devices whose press already needed a deliberate hold.
+type wearableSOS struct {
+	Message   string
+	Confirmed bool
+}
+
+var wearableAdapters = map[string]wearableAdapter{}
+
+// registerWearableAdapter makes a device type registrable and its telemetry ingestible. Types are
+// identifiers and register once.
+func registerWearableAdapter(deviceType string, adapter wearableAdapter) {
+	if !identifierRegex.MatchString(deviceType) {
+		panic(fmt.Errorf("wearable device type %q is not an identifier", deviceType))
+	}
+	if _, ok := wearableAdapters[deviceType]; ok {
+		panic(fmt.Errorf("wearable device type %q registered twice", deviceType))
+	}
+	wearableAdapters[deviceType] = adapter
+}
+
+// wearableDeviceTypes lists the registered device types in order
+func wearableDeviceTypes() []string {
+	return sortedKeys(wearableAdapters)
+}
+
+// ed25519Envelope verifies the signed envelope most bands send, described at attestedPayload.
+// Adapters embed it unless their vendor signs differently.
+type ed25519Envelope struct{}
+
+func (ed25519Envelope) verify(device DeviceDocument, message []byte) ([]byte, bool, error) {
+	return device.attestedPayload(message)
+}
+
+// sosButton raises an unconfirmed SOS for every reading decoded with SOS set. Adapters embed it
+// unless their vendor reports alarms that need a different mapping.
+type sosButton struct{}
+
+func (sosButton) sos(device DeviceDocument, t WearableTelemetry) *wearableSOS {
+	if !t.SOS {
+		return nil
+	}
+	return &wearableSOS{Message: "SOS button pressed on wearable " + device.DeviceID}
+}
@@ -0,0 +1,82 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// heldButton is a vendor whose panic buttons prefix their messages and only report a held press
+type heldButton struct{}
+
+func (heldButton) verify(_ DeviceDocument, message []byte) ([]byte, bool, error) {
+	payload, ok := bytes.CutPrefix(message, []byte("XB1:"))
+	if !ok {
+		return nil, false, errors.New("missing XB1 header")
+	}
+	return payload, true, nil
+}
+
+func (heldButton) decode(payload []byte) (WearableTelemetry, error) {
+	return WearableTelemetry{SOS: string(payload) == "HOLD"}, nil
+}
+
+func (heldButton) sos(device DeviceDocument, t WearableTelemetry) *wearableSOS {
+	if !t.SOS {
+		return nil
+	}
+	return &wearableSOS{Message: "Panic button held on " + device.DeviceID, Confirmed: true}
+}
+
+func TestWearableAdapterRegistry(t *testing.T) {
+	if types := wearableDeviceTypes(); !slices.Equal(types, []string{"sihband", "trackpro"}) {
+		t.Fatalf("expected the built-in adapters, got %v", types)
+	}
+	registerWearableAdapter("heldbutton", heldButton{})
+	defer delete(wearableAdapters, "heldbutton")
+
+	if errs := (RegisterWearableRequest{DeviceID: "btn-1", Type: "heldbutton", Actor: "tourism_desk"}).Validate(); len(errs) > 0 {
+		t.Errorf("expected a registered type accepted, got %v", errs)
+	}
+	for _, deviceType := range []string{"heldbutton", "no spaces"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected registering %q to panic", deviceType)
+				}
+			}()
+			registerWearableAdapter(deviceType, heldButton{})
+		}()
+	}
+
+	// The core uses the adapter's verification and decoding
+	w := &wearableService{store: newMemoryWearableStore(10, time.Hour)}
+	button := DeviceDocument{DeviceID: "btn-1", Type: "heldbutton", DigitalID: "did:sih:tourist_001"}
+	now := time.Now()
+	reading, err := w.ingest(context.Background(), button, []byte("XB1:PING"), now)
+	if err != nil || !reading.Attested || reading.SOS {
+		t.Fatalf("expected an attested reading without an alarm, got %+v, %v", reading, err)
+	}
+	if _, err := w.ingest(context.Background(), button, []byte("PING"), now); err == nil || !strings.Contains(err.Error(), "XB1") {
+		t.Errorf("expected the adapter's verification to reject the message, got %v", err)
+	}
+	button.Type = "unknown"
+	if _, err := w.ingest(context.Background(), button, []byte("XB1:PING"), now); err == nil || !strings.Contains(err.Error(), "no adapter") {
+		t.Errorf("expected a band of an unregistered type rejected, got %v", err)
+	}
+}
+
+func TestSOSButtonMapping(t *testing.T) {
+	band := DeviceDocument{DeviceID: "band-0042"}
+	if event := (sosButton{}).sos(band, WearableTelemetry{}); event != nil {
+		t.Errorf("expected no SOS without a press, got %+v", event)
+	}
+	event := (trackProAdapter{}).sos(band, WearableTelemetry{SOS: true})
+	if event == nil || event.Message != "SOS button pressed on wearable band-0042" || event.Confirmed {
+		t.Errorf("expected an unconfirmed SOS for the press, got %+v", event)
+	}
+}
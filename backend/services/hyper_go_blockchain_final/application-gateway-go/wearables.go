@@ -0,0 +1,383 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	errCodeWearablesDisabled = "WEARABLES_DISABLED"
+
+	wearableTelemetryPrefix = "sih:wearable:telemetry:"
+	maxWearableTelemetry    = 1000
+)
+
+// WearableTelemetry is one normalized reading from a band. Fields the band did not report are
+// omitted.
+type WearableTelemetry struct {
+	DeviceID   string   `json:"device_id"`
+	DigitalID  string   `json:"digital_id"`
+	Latitude   *float64 `json:"latitude,omitempty"`
+	Longitude  *float64 `json:"longitude,omitempty"`
+	Accuracy   float64  `json:"accuracy,omitempty"`
+	Battery    *int     `json:"battery,omitempty"`
+	HeartRate  *int     `json:"heart_rate,omitempty"`
+	SOS        bool     `json:"sos,omitempty"`
+	RecordedAt string   `json:"recorded_at"`
+	ReceivedAt string   `json:"received_at"`
+	// AlertID is the SOS raised for a button press
+	AlertID string `json:"alert_id,omitempty"`
+	// Attested is set when the band signed the reading; BindingID is the on-chain binding that
+	// attributed it to the tourist
+	Attested  bool   `json:"attested"`
+	BindingID string `json:"binding_id"`
+}
+
+// WearableTelemetryRequest pages a band's recent telemetry, newest first
+type WearableTelemetryRequest struct {
+	Limit int `form:"limit"`
+}
+
+func (r WearableTelemetryRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	if r.Limit < 0 || r.Limit > maxWearableTelemetry {
+		v.add("limit", "must be between 1 and %d", maxWearableTelemetry)
+	}
+	return v.errors
+}
+
+// WearableTelemetryPage is a band's recent telemetry
+type WearableTelemetryPage struct {
+	Device    DeviceDocument      `json:"device"`
+	Telemetry []WearableTelemetry `json:"telemetry"`
+}
+
+// validate checks a decoded reading; the band is trusted no more than the app
+func (t WearableTelemetry) validate(now time.Time) error {
+	if (t.Latitude == nil) != (t.Longitude == nil) {
+		return errors.New("has only half of a position")
+	}
+	if t.Latitude != nil && (*t.Latitude < -90 || *t.Latitude > 90 || *t.Longitude < -180 || *t.Longitude > 180) {
+		return errors.New("has a position out of range")
+	}
+	if t.Battery != nil && (*t.Battery < 0 || *t.Battery > 100) {
+		return errors.New("has a battery level out of range")
+	}
+	if t.HeartRate != nil && (*t.HeartRate < 20 || *t.HeartRate > 250) {
+		return errors.New("has a heart rate out of range")
+	}
+	recorded, _ := time.Parse(time.RFC3339, t.RecordedAt)
+	if recorded.After(now.Add(maxLocationPingClockSkew)) || recorded.Before(now.Add(-maxLocationPingAge)) {
+		return fmt.Errorf("was recorded at %s, outside the accepted window", t.RecordedAt)
+	}
+	return nil
+}
+
+// wearableStore keeps each band's most recent readings, newest first
+type wearableStore interface {
+	add(ctx context.Context, t WearableTelemetry) error
+	recent(ctx context.Context, deviceID string, limit int) ([]WearableTelemetry, error)
+}
+
+// wearableService turns band telemetry into location pings and SOS alerts
+type wearableService struct {
+	store  wearableStore
+	mqtt   *mqttClient
+	topics []string
+	// requireAttestation drops telemetry from bands registered without an attestation key
+	requireAttestation bool
+}
+
+var wearables *wearableService
+
+// initWearables subscribes to band telemetry when MQTT_BROKER_URL is set. Runs after initLocation,
+// which fixes are forwarded to, and initDevices, which pairs bands with tourists.
+func initWearables() {
+	broker := getEnv("MQTT_BROKER_URL", "")
+	if broker == "" {
+		log.Println("⌚ MQTT_BROKER_URL not set, wearable telemetry disabled")
+		return
+	}
+
+	client := mqttClientFromEnv(broker, "")
+	topics := splitList(getEnv("MQTT_TOPICS", "sih/bands/+/telemetry"))
+	for _, topic := range topics {
+		if strings.Count(topic, "+") == 0 {
+			panic(fmt.Errorf("MQTT topic %q needs a + level for the device ID", topic))
+		}
+	}
+
+	ttl := getEnvDuration("WEARABLE_TELEMETRY_TTL", 24*time.Hour)
+	retain := getEnvInt("WEARABLE_TELEMETRY_RETAIN", 100)
+	if retain < 1 || retain > maxWearableTelemetry {
+		panic(fmt.Errorf("WEARABLE_TELEMETRY_RETAIN must be between 1 and %d", maxWearableTelemetry))
+	}
+	var store wearableStore = newMemoryWearableStore(retain, ttl)
+	backend := "memory"
+	if documentCache != nil {
+		store, backend = redisWearableStore{documentCache, retain, ttl}, "Redis"
+	}
+	wearables = &wearableService{store: store, mqtt: client, topics: topics, requireAttestation: getEnvBool("WEARABLE_REQUIRE_ATTESTATION", false)}
+	log.Printf("⌚ Receiving wearable telemetry via %s, recent readings kept in %s", client.addr, backend)
+}
+
+func (w *wearableService) run(ctx context.Context) {
+	w.mqtt.run(ctx, w.topics, w.handle)
+}
+
+// handle processes one message. Bad payloads are logged and acknowledged, since redelivery would
+// not fix them; a press whose SOS could not be raised, or whose band could not be looked up, is left
+// unacknowledged for redelivery.
+func (w *wearableService) handle(ctx context.Context, msg mqttMessage) error {
+	deviceID := ""
+	for _, filter := range w.topics {
+		if id, ok := mqttTopicLevel(filter, msg.topic); ok {
+			deviceID = id
+			break
+		}
+	}
+	device, err := devices.lookup(ctx, deviceID)
+	if err != nil {
+		return fmt.Errorf("failed to look up device %s: %w", deviceID, err)
+	}
+	if device == nil || device.DigitalID == "" {
+		log.Printf("⌚ Ignoring telemetry on %s from an unregistered or unbound device", msg.topic)
+		return nil
+	}
+	_, err = w.ingest(ctx, *device, msg.payload, time.Now())
+	var sosErr *wearableSOSError
+	if errors.As(err, &sosErr) {
+		return err
+	}
+	if err != nil {
+		log.Printf("⌚ Failed to process telemetry from %s: %v", device.DeviceID, err)
+	}
+	return nil
+}
+
+var errWearableNoPosition = errors.New("no position from the band or the app to raise an SOS with")
+
+// wearableSOSError is a button press that could not be raised
+type wearableSOSError struct {
+	deviceID string
+	err      error
+}
+
+func (e *wearableSOSError) Error() string {
+	return fmt.Sprintf("failed to raise SOS from %s: %v", e.deviceID, e.err)
+}
+
+func (e *wearableSOSError) Unwrap() error { return e.err }
+
+// ingest has the band type's adapter verify and decode a message, raises the SOS it maps to,
+// forwards its fix to the location pipeline and stores it. Every gateway instance receives each
+// message; the first to raise its SOS or claim it processes it.
+func (w *wearableService) ingest(ctx context.Context, device DeviceDocument, message []byte, now time.Time) (*WearableTelemetry, error) {
+	adapter, ok := wearableAdapters[device.Type]
+	if !ok {
+		return nil, fmt.Errorf("no adapter for device type %q", device.Type)
+	}
+	payload, attested, err := adapter.verify(device, message)
+	if err != nil {
+		return nil, fmt.Errorf("attestation failed: %w", err)
+	}
+	if !attested && w.requireAttestation {
+		return nil, errors.New("attestation failed: the band has no attestation key")
+	}
+	t, err := adapter.decode(payload)
+	if err != nil {
+		return nil, fmt.Errorf("malformed %s payload: %w", device.Type, err)
+	}
+	t.DeviceID, t.DigitalID, t.ReceivedAt = device.DeviceID, device.DigitalID, now.UTC().Format(time.RFC3339)
+	t.Attested, t.BindingID = attested, device.BindingID
+	if t.RecordedAt == "" {
+		t.RecordedAt = t.ReceivedAt
+	}
+	if err := t.validate(now); err != nil {
+		return nil, fmt.Errorf("reading %w", err)
+	}
+
+	event := adapter.sos(device, t)
+	if t.SOS = event != nil; t.SOS {
+		t.AlertID, err = w.raiseSOS(ctx, t, *event)
+		var invalid ValidationErrors
+		switch {
+		case err == nil:
+		case errors.Is(err, errWearableNoPosition), errors.As(err, &invalid):
+			return nil, err
+		case translateFabricError(err).Code == errCodeAlreadyExists:
+			return nil, nil
+		default:
+			return nil, &wearableSOSError{device.DeviceID, err}
+		}
+	}
+	key := "wearable:" + device.DeviceID + ":" + t.RecordedAt + ":" + strconv.FormatBool(t.SOS)
+	if first, err := claimEvent(ctx, key, 24*time.Hour); err != nil || !first {
+		return nil, err
+	}
+
+	if t.Latitude != nil && locations != nil {
+		ping := LocationPing{Latitude: t.Latitude, Longitude: t.Longitude, Accuracy: t.Accuracy, Battery: t.Battery, RecordedAt: t.RecordedAt}
+		if _, err := locations.Ingest(ctx, LocationPingsRequest{DigitalID: device.DigitalID, Pings: []LocationPing{ping}}); err != nil {
+			log.Printf("⌚ Failed to record the position from %s: %v", device.DeviceID, err)
+		}
+	}
+	return &t, w.store.add(ctx, t)
+}
+
+// raiseSOS records the SOS a reading's adapter mapped it to, with source "wearable". A press without a
+// fix uses the tourist's live location. The alert ID is derived from the device and press time, so a
+// redelivered press cannot raise a second alert.
+func (w *wearableService) raiseSOS(ctx context.Context, t WearableTelemetry, event wearableSOS) (string, error) {
+	lat, lng, accuracy := t.Latitude, t.Longitude, t.Accuracy
+	if lat == nil && locations != nil {
+		live, err := locations.store.latest(ctx, t.DigitalID)
+		if err != nil {
+			return "", err
+		}
+		if live != nil {
+			lat, lng, accuracy = &live.Latitude, &live.Longitude, live.Accuracy
+		}
+	}
+	if lat == nil {
+		return "", errWearableNoPosition
+	}
+	recorded, _ := time.Parse(time.RFC3339, t.RecordedAt)
+	alertID := "SOS-" + recorded.Format("20060102T150405Z") + "-" + t.DeviceID
+	if len(alertID) > 128 {
+		alertID = alertID[:128]
+	}
+	result, err := ledger.RaiseSOS(ctx, SOSRequest{
+		AlertID:   alertID,
+		DigitalID: t.DigitalID,
+		Latitude:  lat,
+		Longitude: lng,
+		Accuracy:  accuracy,
+		Message:   event.Message,
+		Source:    "wearable",
+		Confirmed: event.Confirmed,
+	})
+	if err != nil {
+		return "", err
+	}
+	log.Printf("⌚ Raised SOS %s from wearable %s", result.AlertID, t.DeviceID)
+	return result.AlertID, nil
+}
+
+// redisWearableStore keeps a capped list of readings per band, expiring WEARABLE_TELEMETRY_TTL after
+// the latest one
+type redisWearableStore struct {
+	redis  *redisClient
+	retain int
+	ttl    time.Duration
+}
+
+func (s redisWearableStore) add(ctx context.Context, t WearableTelemetry) error {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	key := wearableTelemetryPrefix + t.DeviceID
+	if _, err := s.redis.Do(ctx, "LPUSH", key, string(data)); err != nil {
+		return err
+	}
+	if _, err := s.redis.Do(ctx, "LTRIM", key, "0", strconv.Itoa(s.retain-1)); err != nil {
+		return err
+	}
+	_, err = s.redis.Do(ctx, "PEXPIRE", key, strconv.FormatInt(s.ttl.Milliseconds(), 10))
+	return err
+}
+
+func (s redisWearableStore) recent(ctx context.Context, deviceID string, limit int) ([]WearableTelemetry, error) {
+	reply, err := s.redis.Do(ctx, "LRANGE", wearableTelemetryPrefix+deviceID, "0", strconv.Itoa(limit-1))
+	if err != nil {
+		return nil, err
+	}
+	items, _ := reply.([]interface{})
+	readings := make([]WearableTelemetry, 0, len(items))
+	for _, item := range items {
+		raw, _ := item.([]byte)
+		var t WearableTelemetry
+		if err := json.Unmarshal(raw, &t); err != nil {
+			return nil, err
+		}
+		readings = append(readings, t)
+	}
+	return readings, nil
+}
+
+// memoryWearableStore serves a single gateway instance
+type memoryWearableStore struct {
+	retain int
+	ttl    time.Duration
+
+	mu       sync.Mutex
+	readings map[string][]WearableTelemetry
+}
+
+func newMemoryWearableStore(retain int, ttl time.Duration) *memoryWearableStore {
+	return &memoryWearableStore{retain: retain, ttl: ttl, readings: map[string][]WearableTelemetry{}}
+}
+
+func (s *memoryWearableStore) add(_ context.Context, t WearableTelemetry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	readings := append([]WearableTelemetry{t}, s.readings[t.DeviceID]...)
+	s.readings[t.DeviceID] = readings[:min(len(readings), s.retain)]
+	return nil
+}
+
+func (s *memoryWearableStore) recent(_ context.Context, deviceID string, limit int) ([]WearableTelemetry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	readings := s.readings[deviceID]
+	if len(readings) > 0 {
+		if received, _ := time.Parse(time.RFC3339, readings[0].ReceivedAt); time.Since(received) > s.ttl {
+			delete(s.readings, deviceID)
+			readings = nil
+		}
+	}
+	return append([]WearableTelemetry{}, readings[:min(len(readings), limit)]...), nil
+}
+
+func getWearableTelemetry(c *gin.Context) {
+	if wearables == nil {
+		respondError(c, http.StatusServiceUnavailable, errCodeWearablesDisabled, "Wearable telemetry is not configured")
+		return
+	}
+	var req WearableTelemetryRequest
+	if !bindQuery(c, &req) {
+		return
+	}
+	device, err := ledger.GetDevice(c.Request.Context(), c.Param("deviceId"))
+	if err != nil {
+		respondServiceError(c, "Failed to read wearable telemetry", err)
+		return
+	}
+	limit := req.Limit
+	if limit == 0 {
+		limit = 50
+	}
+	readings, err := wearables.store.recent(c.Request.Context(), device.DeviceID, limit)
+	if err != nil {
+		respondServiceError(c, "Failed to read wearable telemetry", err)
+		return
+	}
+	respondData(c, http.StatusOK, WearableTelemetryPage{Device: device, Telemetry: readings})
+}
@@ -0,0 +1,177 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+func TestMQTTSubscriber(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// A broker that checks the session request, grants the subscription and publishes one QoS 1
+	// message, then reports whether it was acknowledged before the connection ended
+	acked := make(chan error, 2)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			acked <- fakeMQTTSession(conn)
+		}
+	}()
+
+	client, err := newMQTTClient("mqtt://gateway:secret@"+ln.Addr().String(), "sih-gateway-test", 10*time.Second, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	received := make(chan mqttMessage, 2)
+	attempts := 0
+	go client.run(ctx, []string{"sih/bands/+/telemetry"}, func(_ context.Context, msg mqttMessage) error {
+		received <- msg
+		if attempts++; attempts == 1 {
+			return errors.New("ledger unavailable")
+		}
+		return nil
+	})
+
+	// The first delivery fails and is left for the broker to redeliver on the next session
+	for i, want := range []error{errMQTTNotAcked, nil} {
+		select {
+		case msg := <-received:
+			if id, ok := mqttTopicLevel("$share/sih/sih/bands/+/telemetry", msg.topic); !ok || id != "band-7" || string(msg.payload) != `{"sos":true}` {
+				t.Errorf("unexpected message %+v", msg)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("delivery %d not received", i+1)
+		}
+		select {
+		case err := <-acked:
+			if !errors.Is(err, want) {
+				t.Errorf("delivery %d: expected %v, got %v", i+1, want, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("session %d did not end", i+1)
+		}
+	}
+
+	if _, ok := mqttTopicLevel("sih/bands/+/telemetry", "sih/bands/band-7/status"); ok {
+		t.Error("expected a topic on another channel unmatched")
+	}
+}
+
+var errMQTTNotAcked = errors.New("session ended without PUBACK")
+
+// fakeMQTTSession plays the broker's side of one session
+func fakeMQTTSession(conn net.Conn) error {
+	defer conn.Close()
+	packet, err := packets.ReadPacket(conn)
+	if err != nil {
+		return err
+	}
+	connect, ok := packet.(*packets.ConnectPacket)
+	if !ok || connect.CleanSession || connect.Username != "gateway" || string(connect.Password) != "secret" || connect.ClientIdentifier != "sih-gateway-test" {
+		return fmt.Errorf("expected a persistent, authenticated CONNECT, got %v", packet)
+	}
+	if err := packets.NewControlPacket(packets.Connack).Write(conn); err != nil {
+		return err
+	}
+	packet, err = packets.ReadPacket(conn)
+	if err != nil {
+		return err
+	}
+	subscribe, ok := packet.(*packets.SubscribePacket)
+	if !ok || len(subscribe.Topics) != 1 || subscribe.Qoss[0] != 1 {
+		return fmt.Errorf("expected a QoS 1 SUBSCRIBE, got %v", packet)
+	}
+	suback := packets.NewControlPacket(packets.Suback).(*packets.SubackPacket)
+	suback.MessageID, suback.ReturnCodes = subscribe.MessageID, []byte{1}
+	if err := suback.Write(conn); err != nil {
+		return err
+	}
+	publish := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	publish.Qos, publish.MessageID, publish.TopicName, publish.Payload = 1, 42, "sih/bands/band-7/telemetry", []byte(`{"sos":true}`)
+	if err := publish.Write(conn); err != nil {
+		return err
+	}
+	for {
+		packet, err := packets.ReadPacket(conn)
+		if err != nil {
+			return errMQTTNotAcked
+		}
+		switch p := packet.(type) {
+		case *packets.PubackPacket:
+			if p.MessageID != 42 {
+				return fmt.Errorf("acknowledged packet %d", p.MessageID)
+			}
+			return nil
+		case *packets.DisconnectPacket:
+			return errMQTTNotAcked
+		case *packets.PingreqPacket:
+			packets.NewControlPacket(packets.Pingresp).Write(conn)
+		}
+	}
+}
+
+func TestWearableIngest(t *testing.T) {
+	previous := locations
+	locations = &locationService{store: newMemoryLocationStore(time.Hour)}
+	defer func() { locations = previous }()
+	w := &wearableService{store: newMemoryWearableStore(2, time.Hour)}
+	ctx := context.Background()
+	now := time.Now()
+	band := DeviceDocument{DeviceID: "band-7", Type: "trackpro", DigitalID: "did:sih:tourist_001", BindingID: "BIND:band-7:1"}
+
+	payload := fmt.Sprintf(`{"timestamp":%q,"gps":{"fix":true,"lat":25.5788,"lon":91.8933,"hdop":1.2},"battery":{"level":64},"vitals":{"heart_rate":88},"event":"HEARTBEAT"}`,
+		now.Add(-time.Minute).Format(time.RFC3339))
+	reading, err := w.ingest(ctx, band, []byte(payload), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reading.Latitude == nil || *reading.HeartRate != 88 || *reading.Battery != 64 || reading.Accuracy != 6 || reading.Attested || reading.BindingID != band.BindingID {
+		t.Errorf("unexpected reading %+v", reading)
+	}
+	if live, _ := locations.store.latest(ctx, band.DigitalID); live == nil || live.Longitude != 91.8933 {
+		t.Errorf("expected the fix forwarded as the live location, got %+v", live)
+	}
+
+	// Readings without a fix are kept; a dropped fix or an implausible heart rate is not
+	if _, err := w.ingest(ctx, band, []byte(`{"gps":{"fix":false,"lat":0,"lon":0},"battery":{"level":63}}`), now); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.ingest(ctx, band, []byte(`{"vitals":{"heart_rate":400}}`), now); err == nil {
+		t.Error("expected an implausible heart rate rejected")
+	}
+	if readings, _ := w.store.recent(ctx, band.DeviceID, 10); len(readings) != 2 || readings[0].Latitude != nil || readings[1].Latitude == nil {
+		t.Errorf("expected the two readings newest first, got %+v", readings)
+	}
+
+	// A press from a tourist with no known position cannot be raised, and is not retried
+	stranger := DeviceDocument{DeviceID: "band-9", Type: "sihband", DigitalID: "did:sih:tourist_009"}
+	if _, err := w.ingest(ctx, stranger, []byte(`{"sos":true,"bat":20}`), now); !errors.Is(err, errWearableNoPosition) {
+		t.Errorf("expected the press rejected for want of a position, got %v", err)
+	}
+	previousDevices := devices
+	devices = &deviceRegistry{devices: map[string]DeviceDocument{stranger.DeviceID: stranger}, syncedAt: now}
+	defer func() { devices = previousDevices }()
+	w.topics = []string{"sih/bands/+/telemetry"}
+	if err := w.handle(ctx, mqttMessage{topic: "sih/bands/band-9/telemetry", payload: []byte(`{"sos":true}`)}); err != nil {
+		t.Errorf("expected the press acknowledged, got %v", err)
+	}
+	// Once the registry has synced, telemetry from bands it does not hold is dropped without a ledger read
+	if err := w.handle(ctx, mqttMessage{topic: "sih/bands/band-404/telemetry", payload: []byte(`{"sos":true}`)}); err != nil {
+		t.Errorf("expected telemetry from an unregistered band dropped, got %v", err)
+	}
+}
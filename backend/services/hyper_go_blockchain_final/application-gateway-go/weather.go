@@ -0,0 +1,350 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	weatherAlertsKey        = "sih:weather:alerts"
+	maxWeatherAlertDuration = 14 * 24 * time.Hour
+	maxWeatherHeadline      = 200
+)
+
+// weatherSeverities are ordered from least to most severe, following IMD's colour-coded warnings
+var weatherSeverities = []string{"advisory", "watch", "warning"}
+
+// WeatherAlertRequest publishes a weather alert over an area until expiresAt
+type WeatherAlertRequest struct {
+	Severity  string           `json:"severity" binding:"required"`
+	Headline  string           `json:"headline" binding:"required"`
+	Geometry  *GeoJSONGeometry `json:"geometry" binding:"required"`
+	ExpiresAt string           `json:"expiresAt" binding:"required"`
+}
+
+func (r WeatherAlertRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.oneOf("severity", r.Severity, weatherSeverities)
+	if len(r.Headline) > maxWeatherHeadline {
+		v.add("headline", "must be at most %d characters", maxWeatherHeadline)
+	}
+	if r.Geometry != nil {
+		if _, _, err := parseZoneGeometry(*r.Geometry); err != nil {
+			v.add("geometry", "%s", err.Error())
+		}
+	}
+	if expires, ok := v.rfc3339("expiresAt", r.ExpiresAt); ok && (!expires.After(time.Now()) || time.Until(expires) > maxWeatherAlertDuration) {
+		v.add("expiresAt", "must be in the future and within %d days", int(maxWeatherAlertDuration.Hours()/24))
+	}
+	return v.errors
+}
+
+// WeatherAlert is an active weather alert. Alerts are operational data and stay off the ledger;
+// only the digest of an ingested advisory is anchored. ZoneIDs are the zones the alert's area
+// overlaps, which it raises to at least RaisedRisk until it expires.
+type WeatherAlert struct {
+	AlertID    string          `json:"alert_id"`
+	Severity   string          `json:"severity"`
+	Headline   string          `json:"headline"`
+	Geometry   GeoJSONGeometry `json:"geometry"`
+	ExpiresAt  string          `json:"expires_at"`
+	UpdatedAt  string          `json:"updated_at"`
+	ZoneIDs    []string        `json:"zone_ids,omitempty"`
+	RaisedRisk string          `json:"raised_risk,omitempty"`
+	// Source and Reference name the feed and CAP identifier of an ingested advisory
+	Source     string `json:"source,omitempty"`
+	Reference  string `json:"reference,omitempty"`
+	Digest     string `json:"digest,omitempty"`
+	AnchorTxID string `json:"anchor_tx_id,omitempty"`
+	Notified   int    `json:"notified,omitempty"`
+}
+
+func (a WeatherAlert) expired(now time.Time) bool {
+	expires, _ := time.Parse(time.RFC3339, a.ExpiresAt)
+	return !now.Before(expires)
+}
+
+// weatherStore keeps the published alerts in one Redis hash, or in memory
+type weatherStore interface {
+	list(ctx context.Context) ([]WeatherAlert, error)
+	put(ctx context.Context, alert WeatherAlert) error
+	remove(ctx context.Context, alertID string) (bool, error)
+}
+
+var (
+	weatherAlerts weatherStore
+	// weatherRaises is the risk level each alert severity raises its zones to
+	weatherRaises map[string]string
+)
+
+// initWeather runs after initDocumentCache. WEATHER_RISK_RAISE is a list of severity=risk pairs;
+// severities it leaves out raise nothing.
+func initWeather() {
+	weatherAlerts = newMemoryWeatherStore()
+	if documentCache != nil {
+		weatherAlerts = redisWeatherStore{documentCache}
+	}
+	raises, err := parseWeatherRaises(getEnv("WEATHER_RISK_RAISE", "warning=high,watch=medium"))
+	if err != nil {
+		panic(fmt.Errorf("WEATHER_RISK_RAISE %w", err))
+	}
+	weatherRaises = raises
+}
+
+func parseWeatherRaises(value string) (map[string]string, error) {
+	raises := map[string]string{}
+	for _, item := range splitList(value) {
+		severity, risk, _ := strings.Cut(item, "=")
+		severity, risk = strings.TrimSpace(severity), strings.TrimSpace(risk)
+		if weatherSeverity(severity) < 0 {
+			return nil, fmt.Errorf("has unknown severity %q", severity)
+		}
+		if zoneRiskLevel(risk) < 0 {
+			return nil, fmt.Errorf("has unknown risk level %q for %s", risk, severity)
+		}
+		raises[severity] = risk
+	}
+	return raises, nil
+}
+
+// mapWeatherZones records the zones an alert's area overlaps and the risk it raises them to
+func mapWeatherZones(alert *WeatherAlert) {
+	alert.ZoneIDs, alert.RaisedRisk = nil, weatherRaises[alert.Severity]
+	if geofence == nil {
+		return
+	}
+	raw, _ := json.Marshal(alert.Geometry)
+	area, err := newIndexedZone(ZoneDocument{ZoneID: alert.AlertID, Geometry: string(raw)})
+	if err != nil {
+		return
+	}
+	for _, zone := range geofence.overlapping(area) {
+		alert.ZoneIDs = append(alert.ZoneIDs, zone.ZoneID)
+	}
+}
+
+// refreshZoneRaises loads the risk raises of every active alert into the geofence index. Each
+// gateway refreshes after its own changes and every WEATHER_FEED_INTERVAL, which picks up alerts
+// published through other instances.
+func refreshZoneRaises(ctx context.Context) error {
+	if geofence == nil {
+		return nil
+	}
+	alerts, err := weatherAlerts.list(ctx)
+	if err != nil {
+		return err
+	}
+	raises := map[string]zoneRaise{}
+	for _, alert := range alerts {
+		if alert.RaisedRisk == "" {
+			continue
+		}
+		until, _ := time.Parse(time.RFC3339, alert.ExpiresAt)
+		for _, zoneID := range alert.ZoneIDs {
+			// Alerts are listed most severe first, so the first raise of a zone is kept on ties
+			if current, ok := raises[zoneID]; !ok || zoneRiskLevel(alert.RaisedRisk) > zoneRiskLevel(current.level) {
+				raises[zoneID] = zoneRaise{level: alert.RaisedRisk, alertID: alert.AlertID, until: until}
+			}
+		}
+	}
+	geofence.setRaises(raises)
+	return nil
+}
+
+// weatherAt returns the most severe unexpired alert covering a position
+func weatherAt(ctx context.Context, lat, lng float64, at time.Time) (*WeatherAlert, error) {
+	alerts, err := weatherAlerts.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var worst *WeatherAlert
+	for i, alert := range alerts {
+		if alert.expired(at) || (worst != nil && weatherSeverity(alert.Severity) <= weatherSeverity(worst.Severity)) {
+			continue
+		}
+		raw, _ := json.Marshal(alert.Geometry)
+		area, err := newIndexedZone(ZoneDocument{ZoneID: alert.AlertID, Geometry: string(raw)})
+		if err == nil && area.contains(lng, lat) {
+			worst = &alerts[i]
+		}
+	}
+	return worst, nil
+}
+
+func weatherSeverity(severity string) int {
+	for i, candidate := range weatherSeverities {
+		if severity == candidate {
+			return i
+		}
+	}
+	return -1
+}
+
+type redisWeatherStore struct {
+	redis *redisClient
+}
+
+// list also removes expired alerts from the hash
+func (s redisWeatherStore) list(ctx context.Context) ([]WeatherAlert, error) {
+	reply, err := s.redis.Do(ctx, "HVALS", weatherAlertsKey)
+	if err != nil {
+		return nil, err
+	}
+	values, _ := reply.([]interface{})
+	now := time.Now()
+	alerts := make([]WeatherAlert, 0, len(values))
+	for _, value := range values {
+		raw, _ := value.([]byte)
+		var alert WeatherAlert
+		if json.Unmarshal(raw, &alert) != nil {
+			continue
+		}
+		if alert.expired(now) {
+			s.redis.Do(ctx, "HDEL", weatherAlertsKey, alert.AlertID)
+			continue
+		}
+		alerts = append(alerts, alert)
+	}
+	sortWeatherAlerts(alerts)
+	return alerts, nil
+}
+
+func (s redisWeatherStore) put(ctx context.Context, alert WeatherAlert) error {
+	data, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	_, err = s.redis.Do(ctx, "HSET", weatherAlertsKey, alert.AlertID, string(data))
+	return err
+}
+
+func (s redisWeatherStore) remove(ctx context.Context, alertID string) (bool, error) {
+	reply, err := s.redis.Do(ctx, "HDEL", weatherAlertsKey, alertID)
+	n, _ := reply.(int64)
+	return n > 0, err
+}
+
+// memoryWeatherStore serves a single gateway instance
+type memoryWeatherStore struct {
+	mu     sync.Mutex
+	alerts map[string]WeatherAlert
+}
+
+func newMemoryWeatherStore() *memoryWeatherStore {
+	return &memoryWeatherStore{alerts: map[string]WeatherAlert{}}
+}
+
+func (s *memoryWeatherStore) list(context.Context) ([]WeatherAlert, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	alerts := make([]WeatherAlert, 0, len(s.alerts))
+	for id, alert := range s.alerts {
+		if alert.expired(now) {
+			delete(s.alerts, id)
+			continue
+		}
+		alerts = append(alerts, alert)
+	}
+	sortWeatherAlerts(alerts)
+	return alerts, nil
+}
+
+func (s *memoryWeatherStore) put(_ context.Context, alert WeatherAlert) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.alerts[alert.AlertID] = alert
+	return nil
+}
+
+func (s *memoryWeatherStore) remove(_ context.Context, alertID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.alerts[alertID]
+	delete(s.alerts, alertID)
+	return ok, nil
+}
+
+// sortWeatherAlerts orders alerts most severe first, then by ID
+func sortWeatherAlerts(alerts []WeatherAlert) {
+	sort.Slice(alerts, func(i, j int) bool {
+		if a, b := weatherSeverity(alerts[i].Severity), weatherSeverity(alerts[j].Severity); a != b {
+			return a > b
+		}
+		return alerts[i].AlertID < alerts[j].AlertID
+	})
+}
+
+func putWeatherAlert(c *gin.Context) {
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	var req WeatherAlertRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+	setAuditTarget(c, id)
+	expires, _ := time.Parse(time.RFC3339, req.ExpiresAt)
+	alert := WeatherAlert{
+		AlertID:   id,
+		Severity:  req.Severity,
+		Headline:  req.Headline,
+		Geometry:  *req.Geometry,
+		ExpiresAt: expires.UTC().Format(time.RFC3339),
+		UpdatedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+	mapWeatherZones(&alert)
+	if err := weatherAlerts.put(c.Request.Context(), alert); err != nil {
+		respondServiceError(c, "Failed to save weather alert", err)
+		return
+	}
+	if err := refreshZoneRaises(c.Request.Context()); err != nil {
+		logWithContext(c.Request.Context(), "Failed to refresh zone risk raises: %v", err)
+	}
+	respondData(c, http.StatusOK, alert)
+}
+
+func listWeatherAlerts(c *gin.Context) {
+	alerts, err := weatherAlerts.list(c.Request.Context())
+	if err != nil {
+		respondServiceError(c, "Failed to list weather alerts", err)
+		return
+	}
+	respondData(c, http.StatusOK, alerts)
+}
+
+func deleteWeatherAlert(c *gin.Context) {
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	setAuditTarget(c, id)
+	removed, err := weatherAlerts.remove(c.Request.Context(), id)
+	if err != nil {
+		respondServiceError(c, "Failed to delete weather alert", err)
+		return
+	}
+	if !removed {
+		respondError(c, http.StatusNotFound, errCodeNotFound, "Weather alert not found")
+		return
+	}
+	if err := refreshZoneRaises(c.Request.Context()); err != nil {
+		logWithContext(c.Request.Context(), "Failed to refresh zone risk raises: %v", err)
+	}
+	respondData(c, http.StatusOK, gin.H{"message": "Weather alert deleted successfully"})
+}
@@ -0,0 +1,844 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+	gowebauthn "github.com/go-webauthn/webauthn/webauthn"
+)
+
+const (
+	passkeyCredentialPrefix = "sih:webauthn:credentials:"
+	passkeyChallengePrefix  = "sih:webauthn:challenge:"
+	// passkeyAssertionHeader carries a base64url-encoded JSON assertion on routes that need one
+	passkeyAssertionHeader = "X-WebAuthn-Assertion"
+	maxPasskeysPerDID      = 10
+
+	// Actions an assertion can be issued for. Registration challenges are their own ceremony.
+	passkeyCeremonyRegistration  = "registration"
+	passkeyActionRegister        = "passkey.register"
+	passkeyActionDelete          = "passkey.delete"
+	passkeyActionConsentWithdraw = "consent.withdraw"
+	passkeyActionShareCreate     = "share.create"
+	passkeyActionSOSCancel       = "sos.cancel"
+
+	// WEBAUTHN_ENFORCE modes: every DID needs a passkey, or only DIDs that registered one
+	passkeyEnforceAlways     = "always"
+	passkeyEnforceRegistered = "registered"
+
+	errCodeWebAuthnDisabled = "WEBAUTHN_DISABLED"
+	errCodePasskeyRequired  = "PASSKEY_REQUIRED"
+	errCodePasskeyInvalid   = "PASSKEY_INVALID"
+)
+
+var (
+	passkeyActions      = []string{passkeyActionConsentWithdraw, passkeyActionShareCreate, passkeyActionSOSCancel, passkeyActionRegister, passkeyActionDelete}
+	passkeyAlgorithms   = []webauthncose.COSEAlgorithmIdentifier{webauthncose.AlgES256, webauthncose.AlgEdDSA, webauthncose.AlgRS256}
+	passkeyEnforceModes = []string{passkeyEnforceAlways, passkeyEnforceRegistered}
+
+	errPasskeyRequired = errors.New("a passkey assertion is required")
+)
+
+// passkeyInvalidError is a registration or assertion that failed verification
+type passkeyInvalidError struct {
+	reason string
+}
+
+func (e *passkeyInvalidError) Error() string {
+	return "passkey verification failed: " + e.reason
+}
+
+func passkeyInvalid(format string, args ...interface{}) error {
+	return &passkeyInvalidError{reason: fmt.Sprintf(format, args...)}
+}
+
+// Passkey is a WebAuthn credential bound to a DID. The public key is the authenticator's COSE key.
+// A passkey that may be synced between devices stays backup eligible for its lifetime.
+type Passkey struct {
+	CredentialID   string `json:"credential_id"`
+	PublicKey      string `json:"public_key"`
+	Algorithm      int64  `json:"algorithm"`
+	SignCount      uint32 `json:"sign_count"`
+	AAGUID         string `json:"aaguid"`
+	BackupEligible bool   `json:"backup_eligible,omitempty"`
+	Name           string `json:"name,omitempty"`
+	CreatedAt      string `json:"created_at"`
+	LastUsedAt     string `json:"last_used_at,omitempty"`
+}
+
+// RegisterPasskeyRequest is the browser's PublicKeyCredential from navigator.credentials.create,
+// with its buffers base64url-encoded
+type RegisterPasskeyRequest struct {
+	ID       string `json:"id" binding:"required"`
+	Name     string `json:"name"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AttestationObject string `json:"attestationObject"`
+	} `json:"response"`
+}
+
+func (r RegisterPasskeyRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	for _, field := range [][2]string{{"id", r.ID}, {"response.clientDataJSON", r.Response.ClientDataJSON}, {"response.attestationObject", r.Response.AttestationObject}} {
+		if raw, err := decodeBase64URL(field[1]); err != nil || len(raw) == 0 {
+			v.add(field[0], "must be base64url")
+		}
+	}
+	if len(r.Name) > 64 {
+		v.add("name", "must be at most 64 characters")
+	}
+	return v.errors
+}
+
+// AssertionOptionsRequest names the action the assertion will authorize
+type AssertionOptionsRequest struct {
+	Action string `json:"action" binding:"required"`
+}
+
+func (r AssertionOptionsRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.oneOf("action", r.Action, passkeyActions)
+	return v.errors
+}
+
+// PasskeyAssertion is the browser's PublicKeyCredential from navigator.credentials.get, sent
+// base64url-encoded in X-WebAuthn-Assertion
+type PasskeyAssertion struct {
+	ID       string `json:"id"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AuthenticatorData string `json:"authenticatorData"`
+		Signature         string `json:"signature"`
+		UserHandle        string `json:"userHandle,omitempty"`
+	} `json:"response"`
+}
+
+// PasskeyDescriptor names a credential in creation and request options
+type PasskeyDescriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// PasskeyParameter offers a key algorithm in creation options
+type PasskeyParameter struct {
+	Type string `json:"type"`
+	Alg  int64  `json:"alg"`
+}
+
+// CredentialCreationOptions is passed to navigator.credentials.create after decoding the challenge
+// and user ID
+type CredentialCreationOptions struct {
+	Challenge string `json:"challenge"`
+	RP        struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"rp"`
+	User struct {
+		ID          string `json:"id"`
+		Name        string `json:"name"`
+		DisplayName string `json:"displayName"`
+	} `json:"user"`
+	PubKeyCredParams       []PasskeyParameter `json:"pubKeyCredParams"`
+	Timeout                int64              `json:"timeout"`
+	AuthenticatorSelection struct {
+		ResidentKey      string `json:"residentKey"`
+		UserVerification string `json:"userVerification"`
+	} `json:"authenticatorSelection"`
+	Attestation        string              `json:"attestation"`
+	ExcludeCredentials []PasskeyDescriptor `json:"excludeCredentials"`
+}
+
+// CredentialRequestOptions is passed to navigator.credentials.get after decoding the challenge
+type CredentialRequestOptions struct {
+	Challenge        string              `json:"challenge"`
+	RPID             string              `json:"rpId"`
+	Timeout          int64               `json:"timeout"`
+	UserVerification string              `json:"userVerification"`
+	AllowCredentials []PasskeyDescriptor `json:"allowCredentials"`
+	Action           string              `json:"action"`
+}
+
+// webauthnChallenge is issued for one DID and one action and can be answered once. Session is what
+// go-webauthn verifies the answer against.
+type webauthnChallenge struct {
+	Challenge string                 `json:"challenge"`
+	DigitalID string                 `json:"digital_id"`
+	Action    string                 `json:"action"`
+	Session   gowebauthn.SessionData `json:"session"`
+}
+
+// passkeyStore keeps each DID's passkeys and the challenges waiting for an answer
+type passkeyStore interface {
+	list(ctx context.Context, digitalID string) ([]Passkey, error)
+	save(ctx context.Context, digitalID string, passkeys []Passkey) error
+	issue(ctx context.Context, challenge webauthnChallenge, ttl time.Duration) error
+	// take returns a challenge and forgets it, or nil when it was never issued, expired or was used
+	take(ctx context.Context, challenge string) (*webauthnChallenge, error)
+}
+
+// webauthnService registers passkeys and verifies the assertions sensitive tourist actions need.
+// The ceremonies are verified by go-webauthn; the service ties each challenge to a DID and action.
+type webauthnService struct {
+	store        passkeyStore
+	relyingParty *gowebauthn.WebAuthn
+	enforce      string
+	ttl          time.Duration
+}
+
+var webauthn *webauthnService
+
+// initWebAuthn runs after initDocumentCache. Passkeys are enabled by WEBAUTHN_RP_ID, or explicitly by
+// WEBAUTHN_ENABLED or WEBAUTHN_ENFORCE, in which case startup fails without an RP ID rather than
+// leaving the sensitive actions guarded by a bearer token alone.
+func initWebAuthn() {
+	rpID := getEnv("WEBAUTHN_RP_ID", "")
+	if !getEnvBool("WEBAUTHN_ENABLED", rpID != "" || getEnv("WEBAUTHN_ENFORCE", "") != "") {
+		log.Println("👆 Passkeys not enabled, sensitive tourist actions need only a bearer token")
+		return
+	}
+	if rpID == "" {
+		panic(errors.New("passkeys are enabled but WEBAUTHN_RP_ID is not set"))
+	}
+	var store passkeyStore = newMemoryPasskeyStore()
+	backend := "memory"
+	if documentCache != nil {
+		store, backend = redisPasskeyStore{documentCache}, "Redis"
+	}
+	origins := splitList(getEnv("WEBAUTHN_ORIGINS", "https://"+rpID))
+	service, err := newWebAuthnService(store, rpID, getEnv("WEBAUTHN_RP_NAME", "Smart Tourist Safety"), origins,
+		getEnv("WEBAUTHN_ENFORCE", passkeyEnforceAlways), getEnvDuration("WEBAUTHN_CHALLENGE_TTL", 5*time.Minute))
+	if err != nil {
+		panic(err)
+	}
+	webauthn = service
+	log.Printf("👆 Passkeys for %s from %v required %s, stored in %s", rpID, origins, service.enforce, backend)
+}
+
+func newWebAuthnService(store passkeyStore, rpID, rpName string, origins []string, enforce string, ttl time.Duration) (*webauthnService, error) {
+	if !slices.Contains(passkeyEnforceModes, enforce) {
+		return nil, fmt.Errorf("WEBAUTHN_ENFORCE must be one of %v", passkeyEnforceModes)
+	}
+	if ttl <= 0 {
+		return nil, errors.New("WEBAUTHN_CHALLENGE_TTL must be positive")
+	}
+	timeout := gowebauthn.TimeoutConfig{Enforce: true, Timeout: ttl, TimeoutUVD: ttl}
+	relyingParty, err := gowebauthn.New(&gowebauthn.Config{
+		RPID:                  rpID,
+		RPDisplayName:         rpName,
+		RPOrigins:             origins,
+		AttestationPreference: protocol.PreferNoAttestation,
+		AuthenticatorSelection: protocol.AuthenticatorSelection{
+			ResidentKey:      protocol.ResidentKeyRequirementPreferred,
+			UserVerification: protocol.VerificationRequired,
+		},
+		Timeouts: gowebauthn.TimeoutsConfig{Login: timeout, Registration: timeout},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid WebAuthn configuration: %w", err)
+	}
+	return &webauthnService{store: store, relyingParty: relyingParty, enforce: enforce, ttl: ttl}, nil
+}
+
+// passkeyUserID is the opaque user handle a DID's passkeys are created with
+func passkeyUserID(digitalID string) string {
+	return base64.RawURLEncoding.EncodeToString(passkeyUserHandle(digitalID))
+}
+
+func passkeyUserHandle(digitalID string) []byte {
+	sum := sha256.Sum256([]byte(digitalID))
+	return sum[:]
+}
+
+// decodeBase64URL accepts base64url with or without padding, as browsers and libraries differ
+func decodeBase64URL(value string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+}
+
+// passkeyUser presents a DID and its passkeys to go-webauthn
+type passkeyUser struct {
+	digitalID string
+	passkeys  []Passkey
+}
+
+func (u passkeyUser) WebAuthnID() []byte          { return passkeyUserHandle(u.digitalID) }
+func (u passkeyUser) WebAuthnName() string        { return u.digitalID }
+func (u passkeyUser) WebAuthnDisplayName() string { return u.digitalID }
+
+func (u passkeyUser) WebAuthnCredentials() []gowebauthn.Credential {
+	credentials := make([]gowebauthn.Credential, 0, len(u.passkeys))
+	for _, p := range u.passkeys {
+		id, _ := decodeBase64URL(p.CredentialID)
+		publicKey, _ := decodeBase64URL(p.PublicKey)
+		aaguid, _ := hex.DecodeString(p.AAGUID)
+		credentials = append(credentials, gowebauthn.Credential{
+			ID:            id,
+			PublicKey:     publicKey,
+			Flags:         gowebauthn.CredentialFlags{BackupEligible: p.BackupEligible},
+			Authenticator: gowebauthn.Authenticator{AAGUID: aaguid, SignCount: p.SignCount},
+		})
+	}
+	return credentials
+}
+
+func descriptors(passkeys []Passkey) []PasskeyDescriptor {
+	list := make([]PasskeyDescriptor, 0, len(passkeys))
+	for _, p := range passkeys {
+		list = append(list, PasskeyDescriptor{Type: string(protocol.PublicKeyCredentialType), ID: p.CredentialID})
+	}
+	return list
+}
+
+func credentialDescriptors(passkeys []Passkey) []protocol.CredentialDescriptor {
+	return gowebauthn.Credentials(passkeyUser{passkeys: passkeys}.WebAuthnCredentials()).CredentialDescriptors()
+}
+
+// passkeyAlgorithm reads the algorithm of a credential's COSE key, refusing RS256 keys under 2048 bits
+func passkeyAlgorithm(coseKey []byte) (int64, error) {
+	key, err := webauthncose.ParsePublicKey(coseKey)
+	if err != nil {
+		return 0, err
+	}
+	switch key := key.(type) {
+	case webauthncose.EC2PublicKeyData:
+		return key.Algorithm, nil
+	case webauthncose.OKPPublicKeyData:
+		return key.Algorithm, nil
+	case webauthncose.RSAPublicKeyData:
+		if len(key.Modulus) < 256 {
+			return 0, errors.New("RS256 keys must be at least 2048 bits")
+		}
+		return key.Algorithm, nil
+	}
+	return 0, fmt.Errorf("unsupported key type %T", key)
+}
+
+// registrationOptions issues a registration challenge. The DID's existing passkeys are excluded so
+// an authenticator is not registered twice.
+func (s *webauthnService) registrationOptions(ctx context.Context, digitalID string) (*CredentialCreationOptions, error) {
+	existing, err := s.store.list(ctx, digitalID)
+	if err != nil {
+		return nil, err
+	}
+	params := make([]protocol.CredentialParameter, 0, len(passkeyAlgorithms))
+	for _, alg := range passkeyAlgorithms {
+		params = append(params, protocol.CredentialParameter{Type: protocol.PublicKeyCredentialType, Algorithm: alg})
+	}
+	creation, session, err := s.relyingParty.BeginRegistration(passkeyUser{digitalID: digitalID, passkeys: existing},
+		gowebauthn.WithCredentialParameters(params), gowebauthn.WithExclusions(credentialDescriptors(existing)))
+	if err != nil {
+		return nil, err
+	}
+	challenge := webauthnChallenge{Challenge: session.Challenge, DigitalID: digitalID, Action: passkeyCeremonyRegistration, Session: *session}
+	if err := s.store.issue(ctx, challenge, s.ttl); err != nil {
+		return nil, err
+	}
+
+	created := creation.Response
+	options := &CredentialCreationOptions{
+		Challenge:          session.Challenge,
+		Timeout:            int64(created.Timeout),
+		Attestation:        string(created.Attestation),
+		ExcludeCredentials: descriptors(existing),
+	}
+	options.RP.ID, options.RP.Name = created.RelyingParty.ID, created.RelyingParty.Name
+	options.User.ID, options.User.Name, options.User.DisplayName = passkeyUserID(digitalID), created.User.Name, created.User.DisplayName
+	for _, param := range created.Parameters {
+		options.PubKeyCredParams = append(options.PubKeyCredParams, PasskeyParameter{Type: string(param.Type), Alg: int64(param.Algorithm)})
+	}
+	options.AuthenticatorSelection.ResidentKey = string(created.AuthenticatorSelection.ResidentKey)
+	options.AuthenticatorSelection.UserVerification = string(created.AuthenticatorSelection.UserVerification)
+	return options, nil
+}
+
+// assertionOptions issues a challenge for one action, answerable by any of the DID's passkeys
+func (s *webauthnService) assertionOptions(ctx context.Context, digitalID string, req AssertionOptionsRequest) (*CredentialRequestOptions, error) {
+	if errs := req.Validate(); len(errs) > 0 {
+		return nil, errs
+	}
+	existing, err := s.store.list(ctx, digitalID)
+	if err != nil {
+		return nil, err
+	}
+	if len(existing) == 0 {
+		return nil, ValidationErrors{{Field: "digitalId", Message: "has no passkeys registered"}}
+	}
+	assertion, session, err := s.relyingParty.BeginLogin(passkeyUser{digitalID: digitalID, passkeys: existing})
+	if err != nil {
+		return nil, err
+	}
+	challenge := webauthnChallenge{Challenge: session.Challenge, DigitalID: digitalID, Action: req.Action, Session: *session}
+	if err := s.store.issue(ctx, challenge, s.ttl); err != nil {
+		return nil, err
+	}
+	request := assertion.Response
+	return &CredentialRequestOptions{
+		Challenge: session.Challenge, RPID: request.RelyingPartyID, Timeout: int64(request.Timeout),
+		UserVerification: string(request.UserVerification), AllowCredentials: descriptors(existing), Action: req.Action,
+	}, nil
+}
+
+// takeChallenge takes the challenge the client data answers, which must have been issued for this
+// DID and action. A challenge is used up by any attempt, failed or not. Cross-origin ceremonies, from
+// a page framed by another site, are refused.
+func (s *webauthnService) takeChallenge(ctx context.Context, client protocol.CollectedClientData, digitalID, action string) (*webauthnChallenge, error) {
+	challenge, err := s.store.take(ctx, strings.TrimRight(client.Challenge, "="))
+	if err != nil {
+		return nil, err
+	}
+	if challenge == nil || challenge.DigitalID != digitalID || challenge.Action != action {
+		return nil, passkeyInvalid("the challenge was not issued for %s on this DID, or has expired", action)
+	}
+	if client.CrossOrigin {
+		return nil, passkeyInvalid("origin %q is not allowed", client.Origin)
+	}
+	return challenge, nil
+}
+
+// register verifies a new credential and binds it to the DID. Attestation statements are not
+// required: options ask for none, so any authenticator the user verifies on is accepted.
+func (s *webauthnService) register(ctx context.Context, digitalID string, req RegisterPasskeyRequest) (*Passkey, error) {
+	if errs := req.Validate(); len(errs) > 0 {
+		return nil, errs
+	}
+	credentialID, _ := decodeBase64URL(req.ID)
+	clientData, _ := decodeBase64URL(req.Response.ClientDataJSON)
+	attestation, _ := decodeBase64URL(req.Response.AttestationObject)
+	response := protocol.CredentialCreationResponse{
+		PublicKeyCredential: protocol.PublicKeyCredential{
+			Credential: protocol.Credential{ID: base64.RawURLEncoding.EncodeToString(credentialID), Type: string(protocol.PublicKeyCredentialType)},
+			RawID:      credentialID,
+		},
+		AttestationResponse: protocol.AuthenticatorAttestationResponse{
+			AuthenticatorResponse: protocol.AuthenticatorResponse{ClientDataJSON: clientData},
+			AttestationObject:     attestation,
+		},
+	}
+	parsed, err := response.Parse()
+	if err != nil {
+		return nil, passkeyInvalid("%v", err)
+	}
+	challenge, err := s.takeChallenge(ctx, parsed.Response.CollectedClientData, digitalID, passkeyCeremonyRegistration)
+	if err != nil {
+		return nil, err
+	}
+	existing, err := s.store.list(ctx, digitalID)
+	if err != nil {
+		return nil, err
+	}
+	credential, err := s.relyingParty.CreateCredential(passkeyUser{digitalID: digitalID, passkeys: existing}, challenge.Session, parsed)
+	if err != nil {
+		return nil, passkeyInvalid("%v", err)
+	}
+	if !bytes.Equal(credential.ID, credentialID) {
+		return nil, passkeyInvalid("the attested credential does not match id")
+	}
+	alg, err := passkeyAlgorithm(credential.PublicKey)
+	if err != nil {
+		return nil, passkeyInvalid("credential public key: %v", err)
+	}
+
+	passkey := Passkey{
+		CredentialID:   base64.RawURLEncoding.EncodeToString(credential.ID),
+		PublicKey:      base64.RawURLEncoding.EncodeToString(credential.PublicKey),
+		Algorithm:      alg,
+		SignCount:      credential.Authenticator.SignCount,
+		AAGUID:         hex.EncodeToString(credential.Authenticator.AAGUID),
+		BackupEligible: credential.Flags.BackupEligible,
+		Name:           strings.TrimSpace(req.Name),
+		CreatedAt:      time.Now().UTC().Format(time.RFC3339),
+	}
+	for _, p := range existing {
+		if p.CredentialID == passkey.CredentialID {
+			return nil, ValidationErrors{{Field: "id", Message: "is already registered"}}
+		}
+	}
+	if len(existing) >= maxPasskeysPerDID {
+		return nil, ValidationErrors{{Field: "digitalId", Message: fmt.Sprintf("already has %d passkeys; remove one first", maxPasskeysPerDID)}}
+	}
+	if err := s.store.save(ctx, digitalID, append(existing, passkey)); err != nil {
+		return nil, err
+	}
+	return &passkey, nil
+}
+
+// assert verifies an assertion from one of the DID's passkeys for the action and advances its
+// signature counter. A counter that does not move forward suggests a cloned authenticator.
+func (s *webauthnService) assert(ctx context.Context, digitalID, action, header string) error {
+	if header == "" {
+		return errPasskeyRequired
+	}
+	raw, err := decodeBase64URL(header)
+	if err != nil {
+		return passkeyInvalid("%s is not base64url", passkeyAssertionHeader)
+	}
+	var assertion PasskeyAssertion
+	if err := json.Unmarshal(raw, &assertion); err != nil {
+		return passkeyInvalid("%s is not a JSON assertion", passkeyAssertionHeader)
+	}
+	var response protocol.CredentialAssertionResponse
+	fields := []struct {
+		name  string
+		value string
+		into  *protocol.URLEncodedBase64
+	}{
+		{"id", assertion.ID, &response.RawID},
+		{"clientDataJSON", assertion.Response.ClientDataJSON, &response.AssertionResponse.ClientDataJSON},
+		{"authenticatorData", assertion.Response.AuthenticatorData, &response.AssertionResponse.AuthenticatorData},
+		{"signature", assertion.Response.Signature, &response.AssertionResponse.Signature},
+		{"userHandle", assertion.Response.UserHandle, &response.AssertionResponse.UserHandle},
+	}
+	for _, field := range fields {
+		if *field.into, err = decodeBase64URL(field.value); err != nil {
+			return passkeyInvalid("%s is not base64url", field.name)
+		}
+	}
+	response.ID, response.Type = base64.RawURLEncoding.EncodeToString(response.RawID), string(protocol.PublicKeyCredentialType)
+	parsed, err := response.Parse()
+	if err != nil {
+		return passkeyInvalid("%v", err)
+	}
+	challenge, err := s.takeChallenge(ctx, parsed.Response.CollectedClientData, digitalID, action)
+	if err != nil {
+		return err
+	}
+
+	passkeys, err := s.store.list(ctx, digitalID)
+	if err != nil {
+		return err
+	}
+	credential, err := s.relyingParty.ValidateLogin(passkeyUser{digitalID: digitalID, passkeys: passkeys}, challenge.Session, parsed)
+	if err != nil {
+		return passkeyInvalid("%v", err)
+	}
+	passkey := &passkeys[slices.IndexFunc(passkeys, func(p Passkey) bool { return p.CredentialID == response.ID })]
+	if credential.Authenticator.CloneWarning {
+		logWithContext(ctx, "👆 Passkey %s of %s presented counter %d after %d, possibly cloned", passkey.CredentialID, digitalID, parsed.Response.AuthenticatorData.Counter, passkey.SignCount)
+		return passkeyInvalid("the signature counter did not increase")
+	}
+	passkey.SignCount, passkey.LastUsedAt = credential.Authenticator.SignCount, time.Now().UTC().Format(time.RFC3339)
+	return s.store.save(ctx, digitalID, passkeys)
+}
+
+// verify checks the assertion a request carries for a sensitive action. Without one, the request
+// fails when passkeys are enforced for every DID or the DID has registered one.
+func (s *webauthnService) verify(ctx context.Context, digitalID, action, header string) error {
+	if header != "" || s.enforce == passkeyEnforceAlways {
+		return s.assert(ctx, digitalID, action, header)
+	}
+	existing, err := s.store.list(ctx, digitalID)
+	if err != nil {
+		return err
+	}
+	if len(existing) > 0 {
+		return errPasskeyRequired
+	}
+	return nil
+}
+
+// remove forgets one of the DID's passkeys, returning false when it is not registered
+func (s *webauthnService) remove(ctx context.Context, digitalID, credentialID string) (bool, error) {
+	passkeys, err := s.store.list(ctx, digitalID)
+	if err != nil {
+		return false, err
+	}
+	index := slices.IndexFunc(passkeys, func(p Passkey) bool { return p.CredentialID == credentialID })
+	if index < 0 {
+		return false, nil
+	}
+	return true, s.store.save(ctx, digitalID, slices.Delete(passkeys, index, index+1))
+}
+
+func respondPasskeyError(c *gin.Context, action string, err error) {
+	var invalid *passkeyInvalidError
+	switch {
+	case errors.Is(err, errPasskeyRequired):
+		respondError(c, http.StatusUnauthorized, errCodePasskeyRequired, "A passkey assertion is required in the "+passkeyAssertionHeader+" header")
+	case errors.As(err, &invalid):
+		respondError(c, http.StatusForbidden, errCodePasskeyInvalid, invalid.Error())
+	default:
+		respondServiceError(c, action, err)
+	}
+}
+
+// passkeyAsserted verifies the assertion a sensitive action on a DID needs, answering when it fails
+func passkeyAsserted(c *gin.Context, digitalID, action string) bool {
+	if webauthn == nil {
+		return true
+	}
+	if err := webauthn.verify(c.Request.Context(), digitalID, action, c.GetHeader(passkeyAssertionHeader)); err != nil {
+		respondPasskeyError(c, "Failed to verify passkey", err)
+		return false
+	}
+	return true
+}
+
+func webauthnDisabled(c *gin.Context) bool {
+	if webauthn == nil {
+		respondError(c, http.StatusServiceUnavailable, errCodeWebAuthnDisabled, "Passkeys are not enabled")
+		return true
+	}
+	return false
+}
+
+func getPasskeyRegistrationOptions(c *gin.Context) {
+	if webauthnDisabled(c) {
+		return
+	}
+	id, ok := validPathID(c, "digitalId")
+	if !ok {
+		return
+	}
+
+	options, err := webauthn.registrationOptions(c.Request.Context(), id)
+	if err != nil {
+		respondServiceError(c, "Failed to issue a registration challenge", err)
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	respondData(c, http.StatusOK, options)
+}
+
+// registerPasskey binds a new passkey to a DID. Once the DID has one, adding another needs an
+// assertion from an existing passkey, so a stolen bearer token cannot enroll the thief's device.
+func registerPasskey(c *gin.Context) {
+	if webauthnDisabled(c) {
+		return
+	}
+	id, ok := validPathID(c, "digitalId")
+	if !ok {
+		return
+	}
+	var req RegisterPasskeyRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+	setAuditTarget(c, id)
+	ctx := c.Request.Context()
+
+	existing, err := webauthn.store.list(ctx, id)
+	if err != nil {
+		respondServiceError(c, "Failed to read passkeys", err)
+		return
+	}
+	if len(existing) > 0 {
+		if err := webauthn.assert(ctx, id, passkeyActionRegister, c.GetHeader(passkeyAssertionHeader)); err != nil {
+			respondPasskeyError(c, "Failed to verify passkey", err)
+			return
+		}
+	}
+	passkey, err := webauthn.register(ctx, id, req)
+	if err != nil {
+		respondPasskeyError(c, "Failed to register passkey", err)
+		return
+	}
+	respondData(c, http.StatusCreated, passkey)
+}
+
+func getPasskeyAssertionOptions(c *gin.Context) {
+	if webauthnDisabled(c) {
+		return
+	}
+	id, ok := validPathID(c, "digitalId")
+	if !ok {
+		return
+	}
+	var req AssertionOptionsRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+
+	options, err := webauthn.assertionOptions(c.Request.Context(), id, req)
+	if err != nil {
+		respondServiceError(c, "Failed to issue an assertion challenge", err)
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	respondData(c, http.StatusOK, options)
+}
+
+func listPasskeys(c *gin.Context) {
+	if webauthnDisabled(c) {
+		return
+	}
+	id, ok := validPathID(c, "digitalId")
+	if !ok {
+		return
+	}
+
+	passkeys, err := webauthn.store.list(c.Request.Context(), id)
+	if err != nil {
+		respondServiceError(c, "Failed to list passkeys", err)
+		return
+	}
+	if passkeys == nil {
+		passkeys = []Passkey{}
+	}
+	respondData(c, http.StatusOK, passkeys)
+}
+
+// deletePasskey removes a passkey, asserted with any of the DID's passkeys including that one
+func deletePasskey(c *gin.Context) {
+	if webauthnDisabled(c) {
+		return
+	}
+	id, ok := validPathID(c, "digitalId")
+	if !ok {
+		return
+	}
+	credentialID := strings.TrimRight(c.Param("credentialId"), "=")
+	if raw, err := decodeBase64URL(credentialID); err != nil || len(raw) == 0 || len(raw) > 1023 {
+		respondValidationErrors(c, ValidationErrors{{Field: "credentialId", Message: "must be a base64url credential ID"}})
+		return
+	}
+	setAuditTarget(c, id)
+	ctx := c.Request.Context()
+
+	if err := webauthn.assert(ctx, id, passkeyActionDelete, c.GetHeader(passkeyAssertionHeader)); err != nil {
+		respondPasskeyError(c, "Failed to verify passkey", err)
+		return
+	}
+	removed, err := webauthn.remove(ctx, id, credentialID)
+	if err != nil {
+		respondServiceError(c, "Failed to delete passkey", err)
+		return
+	}
+	if !removed {
+		respondError(c, http.StatusNotFound, errCodeNotFound, "No such passkey for this DID")
+		return
+	}
+	respondData(c, http.StatusOK, mutationResult{"digital_id": id, "credential_id": credentialID})
+}
+
+// redisPasskeyStore keeps a DID's passkeys as one JSON list with no expiry, and challenges until
+// their TTL
+type redisPasskeyStore struct {
+	redis *redisClient
+}
+
+func (s redisPasskeyStore) list(ctx context.Context, digitalID string) ([]Passkey, error) {
+	data, err := s.redis.Get(ctx, passkeyCredentialPrefix+digitalID)
+	if errors.Is(err, errRedisNil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var passkeys []Passkey
+	if err := json.Unmarshal(data, &passkeys); err != nil {
+		return nil, err
+	}
+	return passkeys, nil
+}
+
+func (s redisPasskeyStore) save(ctx context.Context, digitalID string, passkeys []Passkey) error {
+	if len(passkeys) == 0 {
+		return s.redis.Del(ctx, passkeyCredentialPrefix+digitalID)
+	}
+	data, err := json.Marshal(passkeys)
+	if err != nil {
+		return err
+	}
+	_, err = s.redis.Do(ctx, "SET", passkeyCredentialPrefix+digitalID, string(data))
+	return err
+}
+
+func (s redisPasskeyStore) issue(ctx context.Context, challenge webauthnChallenge, ttl time.Duration) error {
+	data, err := json.Marshal(challenge)
+	if err != nil {
+		return err
+	}
+	return s.redis.Set(ctx, passkeyChallengePrefix+challenge.Challenge, data, ttl)
+}
+
+func (s redisPasskeyStore) take(ctx context.Context, challenge string) (*webauthnChallenge, error) {
+	reply, err := s.redis.Do(ctx, "GETDEL", passkeyChallengePrefix+challenge)
+	if errors.Is(err, errRedisNil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, _ := reply.([]byte)
+	var issued webauthnChallenge
+	if err := json.Unmarshal(data, &issued); err != nil {
+		return nil, err
+	}
+	return &issued, nil
+}
+
+// memoryPasskeyStore serves a single gateway instance; passkeys are lost on restart
+type memoryPasskeyStore struct {
+	mu         sync.Mutex
+	passkeys   map[string][]Passkey
+	challenges map[string]webauthnChallenge
+	expires    map[string]time.Time
+}
+
+func newMemoryPasskeyStore() *memoryPasskeyStore {
+	return &memoryPasskeyStore{passkeys: map[string][]Passkey{}, challenges: map[string]webauthnChallenge{}, expires: map[string]time.Time{}}
+}
+
+func (s *memoryPasskeyStore) list(_ context.Context, digitalID string) ([]Passkey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.passkeys[digitalID]), nil
+}
+
+func (s *memoryPasskeyStore) save(_ context.Context, digitalID string, passkeys []Passkey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(passkeys) == 0 {
+		delete(s.passkeys, digitalID)
+		return nil
+	}
+	s.passkeys[digitalID] = slices.Clone(passkeys)
+	return nil
+}
+
+func (s *memoryPasskeyStore) issue(_ context.Context, challenge webauthnChallenge, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for key, expires := range s.expires {
+		if now.After(expires) {
+			delete(s.challenges, key)
+			delete(s.expires, key)
+		}
+	}
+	s.challenges[challenge.Challenge], s.expires[challenge.Challenge] = challenge, now.Add(ttl)
+	return nil
+}
+
+func (s *memoryPasskeyStore) take(_ context.Context, challenge string) (*webauthnChallenge, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	issued, ok := s.challenges[challenge]
+	expires := s.expires[challenge]
+	delete(s.challenges, challenge)
+	delete(s.expires, challenge)
+	if !ok || time.Now().After(expires) {
+		return nil, nil
+	}
+	return &issued, nil
+}
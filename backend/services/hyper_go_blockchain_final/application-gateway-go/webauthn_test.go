@@ -0,0 +1,220 @@
+package gateway
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+)
+
+// encodeCBOR writes the subset of CBOR an authenticator needs for its attestation object
+func encodeCBOR(value interface{}) []byte {
+	head := func(major byte, n int) []byte {
+		switch {
+		case n < 24:
+			return []byte{major<<5 | byte(n)}
+		case n < 256:
+			return []byte{major<<5 | 24, byte(n)}
+		}
+		return []byte{major<<5 | 25, byte(n >> 8), byte(n)}
+	}
+	switch v := value.(type) {
+	case int:
+		if v < 0 {
+			return head(1, -1-v)
+		}
+		return head(0, v)
+	case []byte:
+		return append(head(2, len(v)), v...)
+	case string:
+		return append(head(3, len(v)), v...)
+	case [][2]interface{}:
+		out := head(5, len(v))
+		for _, pair := range v {
+			out = append(append(out, encodeCBOR(pair[0])...), encodeCBOR(pair[1])...)
+		}
+		return out
+	}
+	panic("unsupported CBOR value")
+}
+
+// fakeAuthenticator is a platform authenticator holding one ES256 passkey
+type fakeAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	counter      uint32
+}
+
+func (a *fakeAuthenticator) authData(rpID string, flags byte, attested bool) []byte {
+	rpHash := sha256.Sum256([]byte(rpID))
+	data := append(rpHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, a.counter)
+	if attested {
+		data = append(data, make([]byte, 16)...)
+		data = binary.BigEndian.AppendUint16(data, uint16(len(a.credentialID)))
+		data = append(data, a.credentialID...)
+		x, y := make([]byte, 32), make([]byte, 32)
+		a.key.X.FillBytes(x)
+		a.key.Y.FillBytes(y)
+		data = append(data, encodeCBOR([][2]interface{}{{1, 2}, {3, int(webauthncose.AlgES256)}, {-1, 1}, {-2, x}, {-3, y}})...)
+	}
+	return data
+}
+
+func clientDataJSON(ceremony, challenge, origin string) []byte {
+	data, _ := json.Marshal(protocol.CollectedClientData{Type: protocol.CeremonyType(ceremony), Challenge: challenge, Origin: origin})
+	return data
+}
+
+func (a *fakeAuthenticator) register(rpID, challenge, origin string) RegisterPasskeyRequest {
+	attestation := encodeCBOR([][2]interface{}{{"fmt", "none"}, {"attStmt", [][2]interface{}{}}, {"authData", a.authData(rpID, byte(protocol.FlagUserPresent|protocol.FlagUserVerified|protocol.FlagAttestedCredentialData), true)}})
+	req := RegisterPasskeyRequest{ID: base64.RawURLEncoding.EncodeToString(a.credentialID), Name: "Phone"}
+	req.Response.ClientDataJSON = base64.RawURLEncoding.EncodeToString(clientDataJSON("webauthn.create", challenge, origin))
+	req.Response.AttestationObject = base64.RawURLEncoding.EncodeToString(attestation)
+	return req
+}
+
+// assert signs a challenge the way navigator.credentials.get does and encodes it for the header
+func (a *fakeAuthenticator) assert(rpID, challenge, origin string) string {
+	a.counter++
+	authData := a.authData(rpID, byte(protocol.FlagUserPresent|protocol.FlagUserVerified), false)
+	client := clientDataJSON("webauthn.get", challenge, origin)
+	clientHash := sha256.Sum256(client)
+	digest := sha256.Sum256(append(append([]byte(nil), authData...), clientHash[:]...))
+	signature, _ := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	var assertion PasskeyAssertion
+	assertion.ID = base64.RawURLEncoding.EncodeToString(a.credentialID)
+	assertion.Response.ClientDataJSON = base64.RawURLEncoding.EncodeToString(client)
+	assertion.Response.AuthenticatorData = base64.RawURLEncoding.EncodeToString(authData)
+	assertion.Response.Signature = base64.RawURLEncoding.EncodeToString(signature)
+	data, _ := json.Marshal(assertion)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func TestPasskeys(t *testing.T) {
+	const rpID, origin, id = "sih.example", "https://sih.example", "did:sih:tourist_001"
+	service, err := newWebAuthnService(newMemoryPasskeyStore(), rpID, "Smart Tourist Safety", []string{origin}, passkeyEnforceRegistered, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	webauthn = service
+	defer func() { webauthn = nil }()
+	ctx := context.Background()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	phone := &fakeAuthenticator{key: key, credentialID: []byte("credential-0001")}
+	var invalid *passkeyInvalidError
+
+	// Before registering, a DID is not asked for an assertion when enforcing only registered ones
+	if err := webauthn.verify(ctx, id, passkeyActionShareCreate, ""); err != nil {
+		t.Fatalf("expected no assertion needed without a passkey, got %v", err)
+	}
+
+	options, err := webauthn.registrationOptions(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if options.User.ID != passkeyUserID(id) || options.AuthenticatorSelection.UserVerification != "required" || len(options.PubKeyCredParams) != 3 {
+		t.Errorf("unexpected creation options %+v", options)
+	}
+	if _, err := webauthn.register(ctx, id, phone.register(rpID, options.Challenge, "https://evil.example")); !errors.As(err, &invalid) {
+		t.Fatalf("expected another origin refused, got %v", err)
+	}
+	// The refused attempt used up the challenge
+	if _, err := webauthn.register(ctx, id, phone.register(rpID, options.Challenge, origin)); !errors.As(err, &invalid) {
+		t.Fatalf("expected the challenge single-use, got %v", err)
+	}
+	options, _ = webauthn.registrationOptions(ctx, id)
+	passkey, err := webauthn.register(ctx, id, phone.register(rpID, options.Challenge, origin))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if passkey.Algorithm != int64(webauthncose.AlgES256) || passkey.CredentialID != base64.RawURLEncoding.EncodeToString(phone.credentialID) {
+		t.Errorf("unexpected passkey %+v", passkey)
+	}
+
+	// Now the DID needs an assertion for the action it was issued for, and only once
+	if err := webauthn.verify(ctx, id, passkeyActionShareCreate, ""); !errors.Is(err, errPasskeyRequired) {
+		t.Fatalf("expected an assertion required, got %v", err)
+	}
+	request, err := webauthn.assertionOptions(ctx, id, AssertionOptionsRequest{Action: passkeyActionShareCreate})
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := phone.assert(rpID, request.Challenge, origin)
+	if err := webauthn.verify(ctx, "did:sih:tourist_002", passkeyActionShareCreate, header); !errors.As(err, &invalid) {
+		t.Errorf("expected the assertion refused for another DID, got %v", err)
+	}
+	request, _ = webauthn.assertionOptions(ctx, id, AssertionOptionsRequest{Action: passkeyActionSOSCancel})
+	if err := webauthn.verify(ctx, id, passkeyActionShareCreate, phone.assert(rpID, request.Challenge, origin)); !errors.As(err, &invalid) {
+		t.Errorf("expected a challenge for another action refused, got %v", err)
+	}
+	request, _ = webauthn.assertionOptions(ctx, id, AssertionOptionsRequest{Action: passkeyActionShareCreate})
+	header = phone.assert(rpID, request.Challenge, origin)
+	if err := webauthn.verify(ctx, id, passkeyActionShareCreate, header); err != nil {
+		t.Fatalf("expected the assertion accepted, got %v", err)
+	}
+	if err := webauthn.verify(ctx, id, passkeyActionShareCreate, header); !errors.As(err, &invalid) {
+		t.Errorf("expected a replayed assertion refused, got %v", err)
+	}
+
+	// A counter that goes backwards looks like a cloned authenticator
+	phone.counter = 0
+	request, _ = webauthn.assertionOptions(ctx, id, AssertionOptionsRequest{Action: passkeyActionShareCreate})
+	if err := webauthn.verify(ctx, id, passkeyActionShareCreate, phone.assert(rpID, request.Challenge, origin)); !errors.As(err, &invalid) {
+		t.Errorf("expected a stale counter refused, got %v", err)
+	}
+
+	// Cancelling a held SOS without an assertion leaves it held
+	sosConfirm = &sosConfirmation{store: redisPendingSOSStore{redis: startTestRedis(t), ttl: time.Hour, lease: time.Minute}, window: 15 * time.Second, sources: []string{"app"}}
+	defer func() { sosConfirm = nil }()
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/sos", raiseSOS)
+	r.POST("/sos/:id/cancel", cancelSOS)
+	for _, call := range [][2]string{
+		{"/sos", `{"alertID": "SOS-APP-7", "digitalID": "did:sih:tourist_001", "latitude": 25.5788, "longitude": 91.8933}`},
+		{"/sos/SOS-APP-7/cancel", `{"digitalID": "did:sih:tourist_001"}`},
+	} {
+		path := call[0]
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(call[1]))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if path != "/sos" && (w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), errCodePasskeyRequired)) {
+			t.Errorf("expected the cancel refused without a passkey, got %d %s", w.Code, w.Body)
+		}
+	}
+	if p, _ := sosConfirm.store.get(ctx, "SOS-APP-7"); p == nil {
+		t.Error("expected the SOS still held")
+	}
+}
+
+func TestInitWebAuthnRequiresRPID(t *testing.T) {
+	for _, env := range []string{"WEBAUTHN_ENABLED", "WEBAUTHN_ENFORCE"} {
+		t.Run(env, func(t *testing.T) {
+			t.Setenv("WEBAUTHN_RP_ID", "")
+			t.Setenv(env, map[string]string{"WEBAUTHN_ENABLED": "true", "WEBAUTHN_ENFORCE": passkeyEnforceAlways}[env])
+			defer func() {
+				if recover() == nil {
+					t.Error("expected startup to fail without WEBAUTHN_RP_ID")
+				}
+				webauthn = nil
+			}()
+			initWebAuthn()
+		})
+	}
+}
@@ -0,0 +1,1133 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+const (
+	errCodeWorkflowVersionConflict = "WORKFLOW_VERSION_CONFLICT"
+
+	workflowRulesKey   = "sih:workflow:rules"
+	workflowTicketsKey = "sih:workflow:tickets"
+
+	maxWorkflowName       = 200
+	maxWorkflowConditions = 10
+	maxWorkflowActions    = 5
+	maxWorkflowValues     = 20
+	maxWorkflowTitle      = 100
+	maxWorkflowMessage    = 500
+	// maxWorkflowVersions is how many versions of a rule are kept; older ones are dropped
+	maxWorkflowVersions = 20
+
+	workflowOpEq      = "eq"
+	workflowOpNe      = "ne"
+	workflowOpIn      = "in"
+	workflowOpNotIn   = "not_in"
+	workflowOpExists  = "exists"
+	workflowOpAtLeast = "at_least"
+
+	workflowNotifyZoneUnits = "notify_zone_units"
+	workflowCreateTicket    = "create_ticket"
+	workflowNotify          = "notify"
+
+	ticketOpen         = "open"
+	ticketAcknowledged = "acknowledged"
+)
+
+var (
+	// workflowEvents are the chaincode events a rule can react to, one record per event
+	workflowEvents = []string{
+		"CreateDID", "UpdateDID", "DeleteDID",
+		"CreateIncident", "UpdateIncident", "UpdateIncidentStatus", "DeleteIncident", "MergeIncidents",
+		"CreateEvidence", "UpdateEvidence", "DeleteEvidence",
+		"RecordEFIR", "RaiseSOS", "RecordSOSTrigger",
+		"GrantOrgAccess", "RevokeOrgAccess", "RegisterClaimReference", "ResolveLostFoundMatch",
+		"AnchorCaseStage", "AnchorAdvisory", "RecordBroadcast", "RecordSLABreach", "AnchorCall", "AnchorCCTVManifest",
+		"DeleteZone",
+	}
+	workflowOps            = []string{workflowOpEq, workflowOpNe, workflowOpIn, workflowOpNotIn, workflowOpExists, workflowOpAtLeast}
+	workflowActionTypes    = []string{workflowNotifyZoneUnits, workflowCreateTicket, workflowNotify}
+	workflowNotifyChannels = []string{"sms", "email", "push"}
+	ticketStatuses         = []string{ticketOpen, ticketAcknowledged}
+
+	// workflowFieldPattern is a payload field, dotted into nested objects. Fields under incident.
+	// are read from the incident the event's incident_id names.
+	workflowFieldPattern    = regexp.MustCompile(`^[a-z][a-z0-9_]*(\.[a-z][a-z0-9_]*)*$`)
+	workflowTemplatePattern = regexp.MustCompile(`\{\{\s*([a-z][a-z0-9_]*(?:\.[a-z][a-z0-9_]*)*)\s*\}\}`)
+	e164Pattern             = regexp.MustCompile(`^\+[1-9][0-9]{7,14}$`)
+
+	errWorkflowVersionConflict = errors.New("the rule has changed since the base version")
+)
+
+// WorkflowCondition compares one field of the event with Values. Exists takes no values, eq, ne
+// and at_least one, and in and not_in at least one. At_least orders severities from low to
+// critical, and numbers otherwise.
+type WorkflowCondition struct {
+	Field  string   `json:"field"`
+	Op     string   `json:"op"`
+	Values []string `json:"values,omitempty"`
+}
+
+// WorkflowAction is what a matching rule does. notify_zone_units pushes to the official devices
+// subscribed to Zone, or to the zone the event names; create_ticket opens a dashboard ticket;
+// notify sends to one address on Channel, or pushes to the organization's devices. Title and
+// Message may quote event fields as {{field}}.
+type WorkflowAction struct {
+	Type     string `json:"type"`
+	Zone     string `json:"zone,omitempty"`
+	Channel  string `json:"channel,omitempty"`
+	To       string `json:"to,omitempty"`
+	Priority string `json:"priority,omitempty"`
+	Title    string `json:"title,omitempty"`
+	Message  string `json:"message,omitempty"`
+}
+
+// WorkflowDefinition maps a chaincode event to actions, when every condition holds
+type WorkflowDefinition struct {
+	Name       string              `json:"name" binding:"required"`
+	Event      string              `json:"event" binding:"required"`
+	Conditions []WorkflowCondition `json:"conditions"`
+	Actions    []WorkflowAction    `json:"actions" binding:"required"`
+}
+
+func (d WorkflowDefinition) validate(v *fieldValidator, prefix string) {
+	if len(d.Name) > maxWorkflowName {
+		v.add(prefix+"name", "must be at most %d characters", maxWorkflowName)
+	}
+	v.oneOf(prefix+"event", d.Event, workflowEvents)
+	if len(d.Conditions) > maxWorkflowConditions {
+		v.add(prefix+"conditions", "must contain at most %d conditions", maxWorkflowConditions)
+	}
+	for i, condition := range d.Conditions {
+		field := fmt.Sprintf("%sconditions[%d]", prefix, i)
+		if !workflowFieldPattern.MatchString(condition.Field) {
+			v.add(field+".field", "must be a lowercase payload field, dotted into nested objects")
+		}
+		v.oneOf(field+".op", condition.Op, workflowOps)
+		switch n := len(condition.Values); {
+		case condition.Op == workflowOpExists && n != 0:
+			v.add(field+".values", "must be empty for %s", condition.Op)
+		case (condition.Op == workflowOpEq || condition.Op == workflowOpNe || condition.Op == workflowOpAtLeast) && n != 1:
+			v.add(field+".values", "must contain exactly one value for %s", condition.Op)
+		case (condition.Op == workflowOpIn || condition.Op == workflowOpNotIn) && (n == 0 || n > maxWorkflowValues):
+			v.add(field+".values", "must contain between 1 and %d values for %s", maxWorkflowValues, condition.Op)
+		}
+		if condition.Op == workflowOpAtLeast && len(condition.Values) == 1 && severityLevel(condition.Values[0]) < 0 {
+			if _, err := strconv.ParseFloat(condition.Values[0], 64); err != nil {
+				v.add(field+".values", "must be a severity or a number for %s", condition.Op)
+			}
+		}
+	}
+	if len(d.Actions) == 0 || len(d.Actions) > maxWorkflowActions {
+		v.add(prefix+"actions", "must contain between 1 and %d actions", maxWorkflowActions)
+	}
+	for i, action := range d.Actions {
+		field := fmt.Sprintf("%sactions[%d]", prefix, i)
+		v.oneOf(field+".type", action.Type, workflowActionTypes)
+		if action.Zone != "" {
+			if action.Type != workflowNotifyZoneUnits {
+				v.add(field+".zone", "is only used by %s", workflowNotifyZoneUnits)
+			} else {
+				v.identifier(field+".zone", action.Zone)
+			}
+		}
+		if action.Type == workflowNotify {
+			v.oneOf(field+".channel", action.Channel, workflowNotifyChannels)
+		} else if action.Channel != "" || action.To != "" {
+			v.add(field+".channel", "and to are only used by %s", workflowNotify)
+		}
+		switch {
+		case action.Type != workflowNotify:
+		case action.Channel == "push" && action.To != "":
+			v.add(field+".to", "must be empty for push, which reaches the organization's devices")
+		case action.Channel == "sms" && !e164Pattern.MatchString(action.To):
+			v.add(field+".to", "must be a mobile number in E.164 format")
+		case action.Channel == "email":
+			if address, err := mail.ParseAddress(action.To); err != nil || address.Address != action.To {
+				v.add(field+".to", "must be an email address")
+			}
+		}
+		if action.Priority != "" {
+			if action.Type != workflowCreateTicket {
+				v.add(field+".priority", "is only used by %s", workflowCreateTicket)
+			} else {
+				v.oneOf(field+".priority", action.Priority, incidentSeverities)
+			}
+		}
+		if len(action.Title) > maxWorkflowTitle {
+			v.add(field+".title", "must be at most %d characters", maxWorkflowTitle)
+		}
+		if len(action.Message) > maxWorkflowMessage {
+			v.add(field+".message", "must be at most %d characters", maxWorkflowMessage)
+		}
+	}
+}
+
+// WorkflowRuleRequest saves a new version of a rule. BaseVersion, when set, is the version the
+// editor started from, so two operators editing one rule cannot overwrite each other; 0 means the
+// rule must not exist yet.
+type WorkflowRuleRequest struct {
+	WorkflowDefinition
+	Enabled     *bool  `json:"enabled"`
+	BaseVersion *int   `json:"baseVersion"`
+	Actor       string `json:"actor" binding:"required"`
+}
+
+func (r WorkflowRuleRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	r.validate(&v, "")
+	if r.BaseVersion != nil && *r.BaseVersion < 0 {
+		v.add("baseVersion", "must not be negative")
+	}
+	return v.errors
+}
+
+// WorkflowRollbackRequest saves an earlier version of a rule again, as its newest version
+type WorkflowRollbackRequest struct {
+	Version int    `json:"version" binding:"required"`
+	Actor   string `json:"actor" binding:"required"`
+}
+
+func (r WorkflowRollbackRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	if r.Version < 1 {
+		v.add("version", "must be positive")
+	}
+	return v.errors
+}
+
+// WorkflowDryRunRequest evaluates a sample event without acting on it, against the enabled rules
+// or against Rule, a draft that is not saved
+type WorkflowDryRunRequest struct {
+	Event   string              `json:"event" binding:"required"`
+	Payload json.RawMessage     `json:"payload" binding:"required"`
+	Rule    *WorkflowDefinition `json:"rule"`
+}
+
+func (r WorkflowDryRunRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	v.oneOf("event", r.Event, workflowEvents)
+	if _, err := decodeWorkflowPayload(r.Payload); err != nil {
+		v.add("payload", "must be a JSON object")
+	}
+	if r.Rule != nil {
+		r.Rule.validate(&v, "rule.")
+		if r.Rule.Event != r.Event {
+			v.add("rule.event", "must be the event being evaluated")
+		}
+	}
+	return v.errors
+}
+
+// WorkflowTicketRequest acknowledges a ticket
+type WorkflowTicketRequest struct {
+	Actor string `json:"actor" binding:"required"`
+}
+
+type WorkflowTicketListRequest struct {
+	Status string `form:"status"`
+	RuleID string `form:"ruleId"`
+}
+
+func (r WorkflowTicketListRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	if r.Status != "" {
+		v.oneOf("status", r.Status, ticketStatuses)
+	}
+	if r.RuleID != "" {
+		v.identifier("ruleId", r.RuleID)
+	}
+	return v.errors
+}
+
+// WorkflowRule is one version of a rule. Versions are never edited: a change or a rollback saves
+// a new one, and the newest is the one that runs.
+type WorkflowRule struct {
+	RuleID     string              `json:"rule_id"`
+	Version    int                 `json:"version"`
+	Name       string              `json:"name"`
+	Event      string              `json:"event"`
+	Conditions []WorkflowCondition `json:"conditions"`
+	Actions    []WorkflowAction    `json:"actions"`
+	Enabled    bool                `json:"enabled"`
+	UpdatedBy  string              `json:"updated_by"`
+	UpdatedAt  string              `json:"updated_at"`
+	// RolledBackFrom is the earlier version this one restored
+	RolledBackFrom int `json:"rolled_back_from,omitempty"`
+}
+
+// WorkflowTicket is a dashboard ticket a rule opened. Tickets stay off the ledger, like the rules.
+type WorkflowTicket struct {
+	TicketID       string `json:"ticket_id"`
+	RuleID         string `json:"rule_id"`
+	RuleVersion    int    `json:"rule_version"`
+	Event          string `json:"event"`
+	TxID           string `json:"tx_id"`
+	SubjectID      string `json:"subject_id,omitempty"`
+	Priority       string `json:"priority"`
+	Title          string `json:"title"`
+	Message        string `json:"message"`
+	Status         string `json:"status"`
+	CreatedAt      string `json:"created_at"`
+	AcknowledgedBy string `json:"acknowledged_by,omitempty"`
+	AcknowledgedAt string `json:"acknowledged_at,omitempty"`
+}
+
+// WorkflowConditionResult is how one condition fared, with the value the event had
+type WorkflowConditionResult struct {
+	WorkflowCondition
+	Actual  *string `json:"actual"`
+	Matched bool    `json:"matched"`
+}
+
+// WorkflowPlannedAction is an action with its templates filled in. Skipped says why it cannot run.
+type WorkflowPlannedAction struct {
+	WorkflowAction
+	Skipped string `json:"skipped,omitempty"`
+}
+
+// WorkflowRuleResult is a rule evaluated against an event. Actions are only planned when it matched.
+type WorkflowRuleResult struct {
+	RuleID     string                    `json:"rule_id"`
+	Version    int                       `json:"version"`
+	Name       string                    `json:"name"`
+	Matched    bool                      `json:"matched"`
+	Conditions []WorkflowConditionResult `json:"conditions"`
+	Actions    []WorkflowPlannedAction   `json:"actions"`
+}
+
+// WorkflowEvaluation is the outcome of a dry run
+type WorkflowEvaluation struct {
+	Event     string               `json:"event"`
+	SubjectID string               `json:"subject_id,omitempty"`
+	Rules     []WorkflowRuleResult `json:"rules"`
+}
+
+// WorkflowNotification is posted to the notification gateway for notify actions when no SMS
+// provider or SMTP server is configured
+type WorkflowNotification struct {
+	RuleID    string `json:"rule_id"`
+	Version   int    `json:"version"`
+	Event     string `json:"event"`
+	TxID      string `json:"tx_id"`
+	SubjectID string `json:"subject_id,omitempty"`
+	Channel   string `json:"channel"`
+	To        string `json:"to"`
+	Title     string `json:"title"`
+	Message   string `json:"message"`
+}
+
+// workflowStore keeps every rule's versions, oldest first, and the tickets rules opened. It uses
+// Redis when the document cache is configured, so every gateway instance runs the same rules.
+type workflowStore interface {
+	rules(ctx context.Context) (map[string][]WorkflowRule, error)
+	history(ctx context.Context, ruleID string) ([]WorkflowRule, error)
+	putHistory(ctx context.Context, ruleID string, versions []WorkflowRule) error
+	removeRule(ctx context.Context, ruleID string) (bool, error)
+	tickets(ctx context.Context) (map[string]WorkflowTicket, error)
+	ticket(ctx context.Context, ticketID string) (*WorkflowTicket, error)
+	putTicket(ctx context.Context, ticket WorkflowTicket) error
+}
+
+// workflowEngine runs operator rules on the chaincode event stream
+type workflowEngine struct {
+	store workflowStore
+	// mu orders saves on this instance; across instances BaseVersion catches most races
+	mu sync.Mutex
+}
+
+var workflows *workflowEngine
+
+func initWorkflows() {
+	var store workflowStore = newMemoryWorkflowStore()
+	backend := "memory"
+	if documentCache != nil {
+		store, backend = redisWorkflowStore{documentCache}, "Redis"
+	}
+	workflows = &workflowEngine{store: store}
+	log.Printf("⚙️ Workflow rules kept in %s", backend)
+}
+
+// workflowEvent is an event's payload with the incident it refers to, read on first use
+type workflowEvent struct {
+	name    string
+	txID    string
+	payload map[string]any
+	// readIncident reads the incident for incident. fields; dry runs read as the caller
+	readIncident func(ctx context.Context, id string) (IncidentDocument, error)
+	incident     map[string]any
+	incidentRead bool
+}
+
+func decodeWorkflowPayload(data []byte) (map[string]any, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var payload map[string]any
+	if err := decoder.Decode(&payload); err != nil {
+		return nil, err
+	}
+	if payload == nil {
+		return nil, errors.New("payload is not an object")
+	}
+	return payload, nil
+}
+
+// value is a field's value as text. Objects and arrays are compared as their JSON.
+func (e *workflowEvent) value(ctx context.Context, field string) (string, bool) {
+	source := e.payload
+	if rest, ok := strings.CutPrefix(field, "incident."); ok {
+		source, field = e.incidentFields(ctx), rest
+	}
+	path := strings.Split(field, ".")
+	var current any = source
+	for _, key := range path {
+		object, ok := current.(map[string]any)
+		if !ok {
+			return "", false
+		}
+		if current, ok = object[key]; !ok {
+			return "", false
+		}
+	}
+	switch value := current.(type) {
+	case nil:
+		return "", false
+	case string:
+		return value, true
+	case json.Number:
+		return value.String(), true
+	case bool:
+		return strconv.FormatBool(value), true
+	default:
+		data, _ := json.Marshal(value)
+		return string(data), true
+	}
+}
+
+// incidentFields is the incident the event is about: the payload itself for incident events,
+// otherwise the incident its incident_id names
+func (e *workflowEvent) incidentFields(ctx context.Context) map[string]any {
+	if e.payload["doc_type"] == "incident" {
+		return e.payload
+	}
+	if e.incidentRead {
+		return e.incident
+	}
+	e.incidentRead = true
+	id, _ := e.payload["incident_id"].(string)
+	if id == "" || e.readIncident == nil {
+		return nil
+	}
+	incident, err := e.readIncident(ctx, id)
+	if err != nil {
+		log.Printf("⚙️ Failed to read incident %s for workflow rules: %v", id, err)
+		return nil
+	}
+	data, _ := json.Marshal(incident)
+	e.incident, _ = decodeWorkflowPayload(data)
+	return e.incident
+}
+
+// subject names the record the event is about, for messages and tickets
+func (e *workflowEvent) subject() string {
+	for _, field := range []string{"alert_id", "evidence_id", "incident_id", "fir_id", "broadcast_id", "digital_id"} {
+		if id, ok := e.payload[field].(string); ok && id != "" {
+			return id
+		}
+	}
+	return ""
+}
+
+// render fills in a template's {{field}} references; missing fields are left blank
+func (e *workflowEvent) render(ctx context.Context, template string) string {
+	return workflowTemplatePattern.ReplaceAllStringFunc(template, func(ref string) string {
+		value, _ := e.value(ctx, workflowTemplatePattern.FindStringSubmatch(ref)[1])
+		return value
+	})
+}
+
+func (c WorkflowCondition) matches(actual string, present bool) bool {
+	switch c.Op {
+	case workflowOpExists:
+		return present && actual != ""
+	case workflowOpEq:
+		return present && actual == c.Values[0]
+	case workflowOpNe:
+		return !present || actual != c.Values[0]
+	case workflowOpIn:
+		return present && slices.Contains(c.Values, actual)
+	case workflowOpNotIn:
+		return !present || !slices.Contains(c.Values, actual)
+	case workflowOpAtLeast:
+		if !present {
+			return false
+		}
+		if want := severityLevel(c.Values[0]); want >= 0 {
+			return severityLevel(actual) >= want
+		}
+		want, err := strconv.ParseFloat(c.Values[0], 64)
+		got, gotErr := strconv.ParseFloat(actual, 64)
+		return err == nil && gotErr == nil && got >= want
+	}
+	return false
+}
+
+// evaluate checks a rule against the event and, when it matches, plans its actions. Events and
+// dry runs share it, so a dry run shows exactly what the event would do.
+func (e *workflowEvent) evaluate(ctx context.Context, rule WorkflowRule) WorkflowRuleResult {
+	result := WorkflowRuleResult{
+		RuleID: rule.RuleID, Version: rule.Version, Name: rule.Name, Matched: true,
+		Conditions: []WorkflowConditionResult{}, Actions: []WorkflowPlannedAction{},
+	}
+	for _, condition := range rule.Conditions {
+		actual, present := e.value(ctx, condition.Field)
+		outcome := WorkflowConditionResult{WorkflowCondition: condition, Matched: condition.matches(actual, present)}
+		if present {
+			outcome.Actual = &actual
+		}
+		result.Matched = result.Matched && outcome.Matched
+		result.Conditions = append(result.Conditions, outcome)
+	}
+	if !result.Matched {
+		return result
+	}
+
+	subject := e.subject()
+	for _, action := range rule.Actions {
+		planned := WorkflowPlannedAction{WorkflowAction: action}
+		planned.Title = e.render(ctx, action.Title)
+		if planned.Title == "" {
+			planned.Title = rule.Name
+		}
+		planned.Message = e.render(ctx, action.Message)
+		if planned.Message == "" {
+			planned.Message = strings.TrimSpace(e.name + " " + subject)
+		}
+		switch action.Type {
+		case workflowNotifyZoneUnits:
+			if planned.Zone == "" {
+				planned.Zone = e.zone()
+			}
+			if planned.Zone == "" {
+				planned.Skipped = "the event names no zone or police unit"
+			} else if pusher == nil {
+				planned.Skipped = "push notifications are not enabled"
+			}
+		case workflowCreateTicket:
+			if planned.Priority == "" {
+				planned.Priority = "medium"
+				if severity, ok := e.value(ctx, "severity"); ok && severityLevel(severity) >= 0 {
+					planned.Priority = severity
+				} else if severity, ok := e.value(ctx, "incident.severity"); ok && severityLevel(severity) >= 0 {
+					planned.Priority = severity
+				}
+			}
+		case workflowNotify:
+			if action.Channel == "push" && pusher == nil {
+				planned.Skipped = "push notifications are not enabled"
+			}
+		}
+		result.Actions = append(result.Actions, planned)
+	}
+	return result
+}
+
+// zone is the zone the event names, or that of the police unit an SOS was assigned to
+func (e *workflowEvent) zone() string {
+	if zone, ok := e.payload["zone_id"].(string); ok && zone != "" {
+		return zone
+	}
+	unitID, _ := e.payload["police_unit"].(string)
+	if unit := policeUnitByID(unitID); unit != nil {
+		return unit.zone()
+	}
+	return ""
+}
+
+// current is the newest version of every rule, by rule ID
+func (w *workflowEngine) current(ctx context.Context) ([]WorkflowRule, error) {
+	all, err := w.store.rules(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rules := make([]WorkflowRule, 0, len(all))
+	for _, id := range sortedKeys(all) {
+		if versions := all[id]; len(versions) > 0 {
+			rules = append(rules, versions[len(versions)-1])
+		}
+	}
+	return rules, nil
+}
+
+// save stores def as the rule's next version. A rollback passes the version it restores.
+func (w *workflowEngine) save(ctx context.Context, ruleID string, def WorkflowDefinition, enabled bool, actor string, baseVersion *int, rolledBackFrom int) (WorkflowRule, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	versions, err := w.store.history(ctx, ruleID)
+	if err != nil {
+		return WorkflowRule{}, err
+	}
+	latest := 0
+	if len(versions) > 0 {
+		latest = versions[len(versions)-1].Version
+	}
+	if baseVersion != nil && *baseVersion != latest {
+		return WorkflowRule{}, errWorkflowVersionConflict
+	}
+	rule := WorkflowRule{
+		RuleID:         ruleID,
+		Version:        latest + 1,
+		Name:           def.Name,
+		Event:          def.Event,
+		Conditions:     def.Conditions,
+		Actions:        def.Actions,
+		Enabled:        enabled,
+		UpdatedBy:      actor,
+		UpdatedAt:      time.Now().UTC().Format(time.RFC3339),
+		RolledBackFrom: rolledBackFrom,
+	}
+	if rule.Conditions == nil {
+		rule.Conditions = []WorkflowCondition{}
+	}
+	versions = append(versions, rule)
+	if len(versions) > maxWorkflowVersions {
+		versions = versions[len(versions)-maxWorkflowVersions:]
+	}
+	return rule, w.store.putHistory(ctx, ruleID, versions)
+}
+
+// run evaluates the enabled rules for an event and carries out the actions of those that match
+func (w *workflowEngine) run(ctx context.Context, e *workflowEvent) {
+	rules, err := w.current(ctx)
+	if err != nil {
+		log.Printf("⚙️ Failed to load workflow rules: %v", err)
+		return
+	}
+	for _, rule := range rules {
+		if !rule.Enabled || rule.Event != e.name {
+			continue
+		}
+		result := e.evaluate(ctx, rule)
+		if !result.Matched {
+			continue
+		}
+		// Every gateway instance sees the event; the first to claim it for the rule acts on it
+		if first, err := claimEvent(ctx, "workflow:"+e.txID+":"+rule.RuleID, 24*time.Hour); err != nil || !first {
+			continue
+		}
+		log.Printf("⚙️ Workflow rule %s v%d matched %s in %s", rule.RuleID, rule.Version, e.name, e.txID)
+		for i, action := range result.Actions {
+			if action.Skipped != "" {
+				log.Printf("⚙️ Workflow rule %s skipped %s: %s", rule.RuleID, action.Type, action.Skipped)
+				continue
+			}
+			if err := w.execute(ctx, e, rule, i, action); err != nil {
+				log.Printf("⚙️ Workflow rule %s failed to %s: %v", rule.RuleID, action.Type, err)
+			}
+		}
+	}
+}
+
+func (w *workflowEngine) execute(ctx context.Context, e *workflowEvent, rule WorkflowRule, index int, action WorkflowPlannedAction) error {
+	subject := e.subject()
+	org, _ := e.payload["owner_org"].(string)
+	data := map[string]string{"type": "workflow", "rule_id": rule.RuleID, "event": e.name, "subject_id": subject}
+	key := "workflow:" + e.txID + ":" + rule.RuleID + ":" + strconv.Itoa(index)
+
+	switch action.Type {
+	case workflowNotifyZoneUnits:
+		pusher.deliver(ctx, pushAlert{key: key, zone: action.Zone, org: org, title: action.Title, body: action.Message, data: data})
+		return nil
+
+	case workflowCreateTicket:
+		sum := sha256.Sum256([]byte(key))
+		return w.store.putTicket(ctx, WorkflowTicket{
+			TicketID:    "TKT-" + hex.EncodeToString(sum[:6]),
+			RuleID:      rule.RuleID,
+			RuleVersion: rule.Version,
+			Event:       e.name,
+			TxID:        e.txID,
+			SubjectID:   subject,
+			Priority:    action.Priority,
+			Title:       action.Title,
+			Message:     action.Message,
+			Status:      ticketOpen,
+			CreatedAt:   time.Now().UTC().Format(time.RFC3339),
+		})
+
+	case workflowNotify:
+		notification := WorkflowNotification{
+			RuleID: rule.RuleID, Version: rule.Version, Event: e.name, TxID: e.txID, SubjectID: subject,
+			Channel: action.Channel, To: action.To, Title: action.Title, Message: action.Message,
+		}
+		switch {
+		case action.Channel == "push":
+			pusher.deliver(ctx, pushAlert{key: key, org: org, title: action.Title, body: action.Message, data: data})
+			return nil
+		case action.Channel == "sms" && smsGateway != nil:
+			_, err := smsGateway.send(ctx, "escalation", action.To, subject, smsData{Reference: subject, Message: action.Message})
+			return err
+		case action.Channel == "email" && emailer != nil:
+			return emailer.mailer.send(ctx, emailMessage{to: action.To, subject: action.Title, body: action.Message + "\n\nRule: " + rule.Name + "\n"})
+		}
+		return sosNotifier.post(ctx, sosNotifier.gatewayURL, notification)
+	}
+	return fmt.Errorf("unknown action %q", action.Type)
+}
+
+// workflowsFromEvent runs the workflow rules for events on the default target
+func workflowsFromEvent(ctx context.Context, event *client.ChaincodeEvent) {
+	if workflows == nil || !isDefaultTarget(ctx) || !slices.Contains(workflowEvents, event.EventName) {
+		return
+	}
+	payload, err := decodeWorkflowPayload(event.Payload)
+	if err != nil {
+		return
+	}
+	e := &workflowEvent{
+		name:    event.EventName,
+		txID:    event.TransactionID,
+		payload: payload,
+		// The rules act for the gateway, so they see incidents whichever organization owns them
+		readIncident: func(ctx context.Context, id string) (IncidentDocument, error) {
+			result, err := ledger.readDocument(ctx, "ReadIncident", id)
+			if err != nil {
+				return IncidentDocument{}, err
+			}
+			return decodeDocument[IncidentDocument](result, "incident")
+		},
+	}
+	go workflows.run(context.WithoutCancel(ctx), e)
+}
+
+type redisWorkflowStore struct {
+	redis *redisClient
+}
+
+func (s redisWorkflowStore) rules(ctx context.Context) (map[string][]WorkflowRule, error) {
+	return redisHashJSON[[]WorkflowRule](ctx, s.redis, workflowRulesKey)
+}
+
+func (s redisWorkflowStore) history(ctx context.Context, ruleID string) ([]WorkflowRule, error) {
+	reply, err := s.redis.Do(ctx, "HGET", workflowRulesKey, ruleID)
+	if errors.Is(err, errRedisNil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	raw, _ := reply.([]byte)
+	var versions []WorkflowRule
+	if len(raw) > 0 {
+		err = json.Unmarshal(raw, &versions)
+	}
+	return versions, err
+}
+
+func (s redisWorkflowStore) putHistory(ctx context.Context, ruleID string, versions []WorkflowRule) error {
+	data, err := json.Marshal(versions)
+	if err != nil {
+		return err
+	}
+	_, err = s.redis.Do(ctx, "HSET", workflowRulesKey, ruleID, string(data))
+	return err
+}
+
+func (s redisWorkflowStore) removeRule(ctx context.Context, ruleID string) (bool, error) {
+	reply, err := s.redis.Do(ctx, "HDEL", workflowRulesKey, ruleID)
+	n, _ := reply.(int64)
+	return n == 1, err
+}
+
+func (s redisWorkflowStore) tickets(ctx context.Context) (map[string]WorkflowTicket, error) {
+	return redisHashJSON[WorkflowTicket](ctx, s.redis, workflowTicketsKey)
+}
+
+func (s redisWorkflowStore) ticket(ctx context.Context, ticketID string) (*WorkflowTicket, error) {
+	reply, err := s.redis.Do(ctx, "HGET", workflowTicketsKey, ticketID)
+	if errors.Is(err, errRedisNil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	raw, _ := reply.([]byte)
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	var ticket WorkflowTicket
+	if err := json.Unmarshal(raw, &ticket); err != nil {
+		return nil, err
+	}
+	return &ticket, nil
+}
+
+func (s redisWorkflowStore) putTicket(ctx context.Context, ticket WorkflowTicket) error {
+	data, err := json.Marshal(ticket)
+	if err != nil {
+		return err
+	}
+	_, err = s.redis.Do(ctx, "HSET", workflowTicketsKey, ticket.TicketID, string(data))
+	return err
+}
+
+// memoryWorkflowStore serves a single gateway instance
+type memoryWorkflowStore struct {
+	mu        sync.Mutex
+	ruleSet   map[string][]WorkflowRule
+	ticketSet map[string]WorkflowTicket
+}
+
+func newMemoryWorkflowStore() *memoryWorkflowStore {
+	return &memoryWorkflowStore{ruleSet: map[string][]WorkflowRule{}, ticketSet: map[string]WorkflowTicket{}}
+}
+
+func (s *memoryWorkflowStore) rules(_ context.Context) (map[string][]WorkflowRule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rules := make(map[string][]WorkflowRule, len(s.ruleSet))
+	for id, versions := range s.ruleSet {
+		rules[id] = slices.Clone(versions)
+	}
+	return rules, nil
+}
+
+func (s *memoryWorkflowStore) history(_ context.Context, ruleID string) ([]WorkflowRule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.ruleSet[ruleID]), nil
+}
+
+func (s *memoryWorkflowStore) putHistory(_ context.Context, ruleID string, versions []WorkflowRule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ruleSet[ruleID] = slices.Clone(versions)
+	return nil
+}
+
+func (s *memoryWorkflowStore) removeRule(_ context.Context, ruleID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.ruleSet[ruleID]
+	delete(s.ruleSet, ruleID)
+	return ok, nil
+}
+
+func (s *memoryWorkflowStore) tickets(_ context.Context) (map[string]WorkflowTicket, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tickets := make(map[string]WorkflowTicket, len(s.ticketSet))
+	for id, ticket := range s.ticketSet {
+		tickets[id] = ticket
+	}
+	return tickets, nil
+}
+
+func (s *memoryWorkflowStore) ticket(_ context.Context, ticketID string) (*WorkflowTicket, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ticket, ok := s.ticketSet[ticketID]
+	if !ok {
+		return nil, nil
+	}
+	return &ticket, nil
+}
+
+func (s *memoryWorkflowStore) putTicket(_ context.Context, ticket WorkflowTicket) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ticketSet[ticket.TicketID] = ticket
+	return nil
+}
+
+func listWorkflowRules(c *gin.Context) {
+	event := c.Query("event")
+	if event != "" {
+		var v fieldValidator
+		if v.oneOf("event", event, workflowEvents); len(v.errors) > 0 {
+			respondValidationErrors(c, v.errors)
+			return
+		}
+	}
+	rules, err := workflows.current(c.Request.Context())
+	if err != nil {
+		respondServiceError(c, "Failed to list workflow rules", err)
+		return
+	}
+	filtered := []WorkflowRule{}
+	for _, rule := range rules {
+		if event == "" || rule.Event == event {
+			filtered = append(filtered, rule)
+		}
+	}
+	respondData(c, http.StatusOK, filtered)
+}
+
+// workflowHistory reads a rule's versions, answering 404 when there are none
+func workflowHistory(c *gin.Context, id string) ([]WorkflowRule, bool) {
+	versions, err := workflows.store.history(c.Request.Context(), id)
+	if err != nil {
+		respondServiceError(c, "Failed to read workflow rule", err)
+		return nil, false
+	}
+	if len(versions) == 0 {
+		respondError(c, http.StatusNotFound, errCodeNotFound, "No workflow rule with this ID")
+		return nil, false
+	}
+	return versions, true
+}
+
+// getWorkflowRule returns the rule's newest version, or the one ?version= asks for
+func getWorkflowRule(c *gin.Context) {
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	version := 0
+	if raw := c.Query("version"); raw != "" {
+		var err error
+		if version, err = strconv.Atoi(raw); err != nil || version < 1 {
+			var v fieldValidator
+			v.add("version", "must be a positive integer")
+			respondValidationErrors(c, v.errors)
+			return
+		}
+	}
+	versions, ok := workflowHistory(c, id)
+	if !ok {
+		return
+	}
+	if version == 0 {
+		respondData(c, http.StatusOK, versions[len(versions)-1])
+		return
+	}
+	for _, rule := range versions {
+		if rule.Version == version {
+			respondData(c, http.StatusOK, rule)
+			return
+		}
+	}
+	respondError(c, http.StatusNotFound, errCodeNotFound, "No such version of this workflow rule is kept")
+}
+
+func listWorkflowRuleVersions(c *gin.Context) {
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	versions, ok := workflowHistory(c, id)
+	if !ok {
+		return
+	}
+	slices.Reverse(versions)
+	respondData(c, http.StatusOK, versions)
+}
+
+func respondWorkflowError(c *gin.Context, message string, err error) {
+	if errors.Is(err, errWorkflowVersionConflict) {
+		respondError(c, http.StatusConflict, errCodeWorkflowVersionConflict, err.Error())
+		return
+	}
+	respondServiceError(c, message, err)
+}
+
+// putWorkflowRule saves a new version of a rule, creating it when it does not exist
+func putWorkflowRule(c *gin.Context) {
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	var req WorkflowRuleRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+	setAuditTarget(c, id)
+	rule, err := workflows.save(c.Request.Context(), id, req.WorkflowDefinition, req.Enabled == nil || *req.Enabled, req.Actor, req.BaseVersion, 0)
+	if err != nil {
+		respondWorkflowError(c, "Failed to save workflow rule", err)
+		return
+	}
+	respondData(c, http.StatusOK, rule)
+}
+
+// rollbackWorkflowRule saves a kept version again as the newest, keeping whether it was enabled
+func rollbackWorkflowRule(c *gin.Context) {
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	var req WorkflowRollbackRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+	versions, ok := workflowHistory(c, id)
+	if !ok {
+		return
+	}
+	index := slices.IndexFunc(versions, func(rule WorkflowRule) bool { return rule.Version == req.Version })
+	if index < 0 {
+		respondError(c, http.StatusNotFound, errCodeNotFound, "No such version of this workflow rule is kept")
+		return
+	}
+	restored := versions[index]
+	base := versions[len(versions)-1].Version
+	setAuditTarget(c, id)
+	def := WorkflowDefinition{Name: restored.Name, Event: restored.Event, Conditions: restored.Conditions, Actions: restored.Actions}
+	rule, err := workflows.save(c.Request.Context(), id, def, restored.Enabled, req.Actor, &base, restored.Version)
+	if err != nil {
+		respondWorkflowError(c, "Failed to roll back workflow rule", err)
+		return
+	}
+	respondData(c, http.StatusOK, rule)
+}
+
+func deleteWorkflowRule(c *gin.Context) {
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	setAuditTarget(c, id)
+	removed, err := workflows.store.removeRule(c.Request.Context(), id)
+	if err != nil {
+		respondServiceError(c, "Failed to delete workflow rule", err)
+		return
+	}
+	if !removed {
+		respondError(c, http.StatusNotFound, errCodeNotFound, "No workflow rule with this ID")
+		return
+	}
+	respondData(c, http.StatusOK, gin.H{"message": "Workflow rule deleted successfully", "ruleID": id})
+}
+
+// dryRunWorkflow evaluates a sample event against the enabled rules, or a draft rule, and returns
+// what they would do without doing it. Incident fields are read as the caller.
+func dryRunWorkflow(c *gin.Context) {
+	var req WorkflowDryRunRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+	ctx := c.Request.Context()
+	payload, _ := decodeWorkflowPayload(req.Payload)
+	e := &workflowEvent{name: req.Event, payload: payload, readIncident: ledger.GetIncident}
+
+	var rules []WorkflowRule
+	if req.Rule != nil {
+		rules = []WorkflowRule{{RuleID: "draft", Name: req.Rule.Name, Event: req.Rule.Event, Conditions: req.Rule.Conditions, Actions: req.Rule.Actions, Enabled: true}}
+	} else {
+		current, err := workflows.current(ctx)
+		if err != nil {
+			respondServiceError(c, "Failed to load workflow rules", err)
+			return
+		}
+		for _, rule := range current {
+			if rule.Enabled && rule.Event == req.Event {
+				rules = append(rules, rule)
+			}
+		}
+	}
+	evaluation := WorkflowEvaluation{Event: req.Event, SubjectID: e.subject(), Rules: []WorkflowRuleResult{}}
+	for _, rule := range rules {
+		evaluation.Rules = append(evaluation.Rules, e.evaluate(ctx, rule))
+	}
+	respondData(c, http.StatusOK, evaluation)
+}
+
+func listWorkflowTickets(c *gin.Context) {
+	var req WorkflowTicketListRequest
+	if !bindQuery(c, &req) {
+		return
+	}
+	all, err := workflows.store.tickets(c.Request.Context())
+	if err != nil {
+		respondServiceError(c, "Failed to list workflow tickets", err)
+		return
+	}
+	tickets := []WorkflowTicket{}
+	for _, ticket := range all {
+		if (req.Status == "" || ticket.Status == req.Status) && (req.RuleID == "" || ticket.RuleID == req.RuleID) {
+			tickets = append(tickets, ticket)
+		}
+	}
+	sort.Slice(tickets, func(i, j int) bool {
+		if tickets[i].CreatedAt != tickets[j].CreatedAt {
+			return tickets[i].CreatedAt > tickets[j].CreatedAt
+		}
+		return tickets[i].TicketID < tickets[j].TicketID
+	})
+	respondData(c, http.StatusOK, tickets)
+}
+
+func workflowTicket(c *gin.Context, id string) (*WorkflowTicket, bool) {
+	ticket, err := workflows.store.ticket(c.Request.Context(), id)
+	if err != nil {
+		respondServiceError(c, "Failed to read workflow ticket", err)
+		return nil, false
+	}
+	if ticket == nil {
+		respondError(c, http.StatusNotFound, errCodeNotFound, "No workflow ticket with this ID")
+		return nil, false
+	}
+	return ticket, true
+}
+
+func getWorkflowTicket(c *gin.Context) {
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	if ticket, ok := workflowTicket(c, id); ok {
+		respondData(c, http.StatusOK, ticket)
+	}
+}
+
+// acknowledgeWorkflowTicket takes a ticket off the open list. Acknowledging it again changes nothing.
+func acknowledgeWorkflowTicket(c *gin.Context) {
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	var req WorkflowTicketRequest
+	if !bindRequest(c, &req) {
+		return
+	}
+	ticket, ok := workflowTicket(c, id)
+	if !ok {
+		return
+	}
+	setAuditTarget(c, id)
+	if ticket.Status == ticketOpen {
+		ticket.Status = ticketAcknowledged
+		ticket.AcknowledgedBy = req.Actor
+		ticket.AcknowledgedAt = time.Now().UTC().Format(time.RFC3339)
+		if err := workflows.store.putTicket(c.Request.Context(), *ticket); err != nil {
+			respondServiceError(c, "Failed to acknowledge workflow ticket", err)
+			return
+		}
+	}
+	respondData(c, http.StatusOK, ticket)
+}
@@ -0,0 +1,150 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestWorkflowEvaluate(t *testing.T) {
+	ctx := context.Background()
+	payload, _ := decodeWorkflowPayload([]byte(`{"doc_type": "evidence", "evidence_id": "EV-1", "incident_id": "INC-1", "media_type": "video/mp4", "owner_org": "Org1MSP"}`))
+	reads := 0
+	e := &workflowEvent{name: "CreateEvidence", txID: "tx1", payload: payload,
+		readIncident: func(_ context.Context, id string) (IncidentDocument, error) {
+			reads++
+			return IncidentDocument{IncidentID: id, Severity: "critical", Category: "theft"}, nil
+		}}
+	rule := WorkflowRule{RuleID: "forensics", Version: 2, Name: "Critical evidence", Event: "CreateEvidence",
+		Conditions: []WorkflowCondition{
+			{Field: "incident.severity", Op: workflowOpAtLeast, Values: []string{"high"}},
+			{Field: "media_type", Op: workflowOpIn, Values: []string{"video/mp4", "image/jpeg"}},
+			{Field: "cid", Op: workflowOpNe, Values: []string{"x"}},
+		},
+		Actions: []WorkflowAction{
+			{Type: workflowNotify, Channel: "email", To: "forensics@police.example", Message: "Evidence {{evidence_id}} on {{incident.category}} incident {{incident_id}}"},
+			{Type: workflowCreateTicket},
+			{Type: workflowNotifyZoneUnits},
+		}}
+
+	result := e.evaluate(ctx, rule)
+	if !result.Matched || reads != 1 {
+		t.Fatalf("expected the rule matched with the incident read once, got %+v after %d reads", result, reads)
+	}
+	if got := result.Actions[0]; got.Title != "Critical evidence" || got.Message != "Evidence EV-1 on theft incident INC-1" {
+		t.Errorf("expected the templates filled in, got %+v", got)
+	}
+	if got := result.Actions[1]; got.Priority != "critical" {
+		t.Errorf("expected the ticket to take the incident's severity, got %q", got.Priority)
+	}
+	if got := result.Actions[2]; got.Skipped == "" {
+		t.Error("expected zone units skipped without a zone")
+	}
+	if c := result.Conditions[2]; !c.Matched || c.Actual != nil {
+		t.Errorf("expected ne to hold for a missing field, got %+v", c)
+	}
+
+	rule.Conditions[0].Values = []string{"critical"}
+	e.payload["media_type"] = "application/pdf"
+	if result := e.evaluate(ctx, rule); result.Matched || len(result.Actions) != 0 || !result.Conditions[0].Matched {
+		t.Errorf("expected only the media type to fail, got %+v", result)
+	}
+}
+
+func TestWorkflowSOSZone(t *testing.T) {
+	saved := policeUnits
+	defer func() { policeUnits = saved }()
+	policeUnits = []PoliceUnit{{ID: "UNIT-7", Name: "Laitumkhrah", Zone: "ZONE-EAST"}}
+	payload, _ := decodeWorkflowPayload([]byte(`{"alert_id": "SOS-1", "digital_id": "did:sih:tourist001", "police_unit": "UNIT-7"}`))
+	e := &workflowEvent{name: "RaiseSOS", payload: payload}
+	if zone := e.zone(); zone != "ZONE-EAST" {
+		t.Errorf("expected the assigned unit's zone, got %q", zone)
+	}
+	if subject := e.subject(); subject != "SOS-1" {
+		t.Errorf("expected the alert as subject, got %q", subject)
+	}
+}
+
+func TestWorkflowVersions(t *testing.T) {
+	w := &workflowEngine{store: newMemoryWorkflowStore()}
+	ctx := context.Background()
+	def := WorkflowDefinition{Name: "SOS", Event: "RaiseSOS", Actions: []WorkflowAction{{Type: workflowCreateTicket}}}
+
+	zero := 0
+	first, err := w.save(ctx, "sos", def, true, "ops", &zero, 0)
+	if err != nil || first.Version != 1 {
+		t.Fatalf("expected version 1, got %+v, %v", first, err)
+	}
+	if _, err := w.save(ctx, "sos", def, true, "ops", &zero, 0); !errors.Is(err, errWorkflowVersionConflict) {
+		t.Errorf("expected a stale base version refused, got %v", err)
+	}
+	def.Name = "SOS v2"
+	if second, err := w.save(ctx, "sos", def, false, "ops", nil, 0); err != nil || second.Version != 2 {
+		t.Fatalf("expected version 2, got %+v, %v", second, err)
+	}
+	current, _ := w.current(ctx)
+	if len(current) != 1 || current[0].Name != "SOS v2" || current[0].Enabled {
+		t.Errorf("expected the newest version current, got %+v", current)
+	}
+
+	for range maxWorkflowVersions {
+		w.save(ctx, "sos", def, true, "ops", nil, 0)
+	}
+	history, _ := w.store.history(ctx, "sos")
+	if len(history) != maxWorkflowVersions || history[len(history)-1].Version != maxWorkflowVersions+2 {
+		t.Errorf("expected the oldest versions dropped, got %d ending at %d", len(history), history[len(history)-1].Version)
+	}
+}
+
+func TestWorkflowRunOpensTicket(t *testing.T) {
+	saved := workflows
+	defer func() { workflows = saved }()
+	workflows = &workflowEngine{store: newMemoryWorkflowStore()}
+	ctx := context.Background()
+	def := WorkflowDefinition{Name: "SOS raised", Event: "RaiseSOS",
+		Conditions: []WorkflowCondition{{Field: "digital_id", Op: workflowOpExists}},
+		Actions:    []WorkflowAction{{Type: workflowCreateTicket, Title: "SOS from {{digital_id}}"}}}
+	workflows.save(ctx, "sos", def, true, "ops", nil, 0)
+	workflows.save(ctx, "disabled", def, false, "ops", nil, 0)
+
+	payload, _ := decodeWorkflowPayload([]byte(`{"alert_id": "SOS-1", "digital_id": "did:sih:tourist001"}`))
+	workflows.run(ctx, &workflowEvent{name: "RaiseSOS", txID: "tx-sos", payload: payload})
+	tickets, _ := workflows.store.tickets(ctx)
+	if len(tickets) != 1 {
+		t.Fatalf("expected one ticket from the enabled rule, got %+v", tickets)
+	}
+	for _, ticket := range tickets {
+		if ticket.Title != "SOS from did:sih:tourist001" || ticket.SubjectID != "SOS-1" || ticket.Status != ticketOpen || ticket.Priority != "medium" {
+			t.Errorf("unexpected ticket %+v", ticket)
+		}
+	}
+}
+
+func TestWorkflowRuleRequestValidate(t *testing.T) {
+	valid := WorkflowRuleRequest{Actor: "ops", WorkflowDefinition: WorkflowDefinition{Name: "Forensics", Event: "CreateEvidence",
+		Conditions: []WorkflowCondition{{Field: "incident.severity", Op: workflowOpEq, Values: []string{"critical"}}},
+		Actions:    []WorkflowAction{{Type: workflowNotify, Channel: "sms", To: "+919876543210"}}}}
+	if errs := valid.Validate(); len(errs) > 0 {
+		t.Errorf("expected a valid rule, got %v", errs)
+	}
+	for name, change := range map[string]func(*WorkflowRuleRequest){
+		"unknown event":     func(r *WorkflowRuleRequest) { r.Event = "Transfer" },
+		"bad field":         func(r *WorkflowRuleRequest) { r.Conditions[0].Field = "Incident.Severity" },
+		"exists with value": func(r *WorkflowRuleRequest) { r.Conditions[0].Op = workflowOpExists },
+		"at_least text": func(r *WorkflowRuleRequest) {
+			r.Conditions[0].Op, r.Conditions[0].Values = workflowOpAtLeast, []string{"lots"}
+		},
+		"local number":   func(r *WorkflowRuleRequest) { r.Actions[0].To = "9876543210" },
+		"push address":   func(r *WorkflowRuleRequest) { r.Actions[0].Channel = "push" },
+		"zone on notify": func(r *WorkflowRuleRequest) { r.Actions[0].Zone = "ZONE-1" },
+		"no actions":     func(r *WorkflowRuleRequest) { r.Actions = nil },
+	} {
+		req := valid
+		req.Conditions = append([]WorkflowCondition(nil), valid.Conditions...)
+		req.Actions = append([]WorkflowAction(nil), valid.Actions...)
+		change(&req)
+		if errs := req.Validate(); len(errs) == 0 {
+			t.Errorf("expected %s rejected", name)
+		}
+	}
+}
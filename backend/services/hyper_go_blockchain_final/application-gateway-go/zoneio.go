@@ -0,0 +1,834 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	kmlMediaType     = "application/vnd.google-earth.kml+xml"
+	kmlNamespace     = "http://www.opengis.net/kml/2.2"
+	geoJSONMediaType = "application/geo+json"
+	maxZoneSimplify  = 1000 // metres
+)
+
+// Zone import row outcomes
+const (
+	zoneImportCreated = "created"
+	zoneImportUpdated = "updated"
+	zoneImportExists  = "exists"
+	zoneImportInvalid = "invalid"
+	zoneImportFailed  = "failed"
+)
+
+var zoneExportFormats = []string{"geojson", "kml"}
+
+type zoneImportConfig struct {
+	maxBytes    int64
+	maxFeatures int
+	concurrency int
+}
+
+var zoneImport = zoneImportConfig{maxBytes: 10 << 20, maxFeatures: 500, concurrency: 4}
+
+// initZoneImport sizes the files accepted by POST /geofence/zones/import
+func initZoneImport() {
+	zoneImport = zoneImportConfig{
+		maxBytes:    int64(getEnvInt("ZONE_IMPORT_MAX_BYTES", 10<<20)),
+		maxFeatures: getEnvInt("ZONE_IMPORT_MAX_FEATURES", 500),
+		concurrency: getEnvInt("ZONE_IMPORT_CONCURRENCY", 4),
+	}
+	if zoneImport.maxFeatures < 1 || zoneImport.concurrency < 1 {
+		panic(fmt.Errorf("ZONE_IMPORT_MAX_FEATURES and ZONE_IMPORT_CONCURRENCY must be positive"))
+	}
+}
+
+// ZoneImportRequest controls a zone import. Simplify is a tolerance in metres for dropping
+// vertices that barely change a zone's outline. Replace updates zones that already exist instead
+// of leaving them alone, and RejectOverlaps refuses zones that share area with another.
+type ZoneImportRequest struct {
+	Actor          string  `form:"actor" binding:"required"`
+	DryRun         bool    `form:"dryRun"`
+	Replace        bool    `form:"replace"`
+	Simplify       float64 `form:"simplify"`
+	RejectOverlaps bool    `form:"rejectOverlaps"`
+}
+
+func (r ZoneImportRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	if r.Simplify < 0 || r.Simplify > maxZoneSimplify {
+		v.add("simplify", "must be between 0 and %d metres", maxZoneSimplify)
+	}
+	return v.errors
+}
+
+// ZoneImportRow is the outcome of one feature or placemark, counted from 1 in file order.
+// Overlaps lists the existing and imported zones it shares area with. SimplifiedFrom is the vertex
+// count before simplification, when it dropped any.
+type ZoneImportRow struct {
+	Row            int      `json:"row"`
+	ZoneID         string   `json:"zone_id"`
+	Name           string   `json:"name,omitempty"`
+	Status         string   `json:"status"`
+	Vertices       int      `json:"vertices,omitempty"`
+	SimplifiedFrom int      `json:"simplified_from,omitempty"`
+	Overlaps       []string `json:"overlaps,omitempty"`
+	TxID           string   `json:"tx_id,omitempty"`
+	Errors         []string `json:"errors,omitempty"`
+}
+
+// ZoneImportReport summarizes an import. On a dry run, rows report what the import would do and
+// nothing is written.
+type ZoneImportReport struct {
+	DryRun   bool            `json:"dry_run"`
+	Total    int             `json:"total"`
+	Created  int             `json:"created"`
+	Updated  int             `json:"updated"`
+	Existing int             `json:"existing"`
+	Invalid  int             `json:"invalid"`
+	Failed   int             `json:"failed"`
+	Rows     []ZoneImportRow `json:"rows"`
+}
+
+// ZoneExportRequest chooses the export format, GeoJSON by default
+type ZoneExportRequest struct {
+	Format string `form:"format"`
+}
+
+func (r ZoneExportRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	if r.Format != "" {
+		v.oneOf("format", r.Format, zoneExportFormats)
+	}
+	return v.errors
+}
+
+// importedZone is one feature or placemark as read from a file, before validation. Err is set
+// when the feature could not be read as a zone at all.
+type importedZone struct {
+	ZoneID    string
+	Name      string
+	RiskLevel string
+	Geometry  GeoJSONGeometry
+	Hours     *ZoneHours
+	Err       error
+}
+
+// zoneProperties reads zone fields from GeoJSON properties or KML data, whose keys match any of
+// the camelCase and snake_case spellings
+type zoneProperties map[string]string
+
+func (p zoneProperties) get(names ...string) string {
+	for _, name := range names {
+		if value := strings.TrimSpace(p[name]); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// zone builds an imported zone from properties and a geometry, turning a point with a radius
+// into a Circle
+func (p zoneProperties) zone(fallbackID string, geometry GeoJSONGeometry) importedZone {
+	z := importedZone{
+		ZoneID:    p.get("zoneID", "zone_id", "zoneId", "id"),
+		Name:      p.get("name", "Name"),
+		RiskLevel: strings.ToLower(p.get("riskLevel", "risk_level", "risk")),
+		Geometry:  geometry,
+	}
+	if z.ZoneID == "" {
+		z.ZoneID = fallbackID
+	}
+	if start, end := p.get("hoursStart", "hours_start", "activeFrom", "active_from"), p.get("hoursEnd", "hours_end", "activeTo", "active_to"); start != "" || end != "" {
+		z.Hours = &ZoneHours{Start: start, End: end, Timezone: p.get("timezone")}
+	}
+	if geometry.Type == "Point" {
+		radius, err := strconv.ParseFloat(p.get("radius", "radiusMeters", "radius_m"), 64)
+		if err != nil {
+			z.Err = errors.New("geometry: a Point needs a radius property in metres to be a circular zone")
+		}
+		z.Geometry.Type, z.Geometry.Radius = "Circle", radius
+	}
+	return z
+}
+
+// parseZoneFile reads zones from GeoJSON, KML, or a multipart form with either in its file field
+func parseZoneFile(contentType string, body io.Reader) ([]importedZone, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = "application/json"
+	}
+	switch mediaType {
+	case "multipart/form-data":
+		return parseMultipartZones(body, params["boundary"])
+	case kmlMediaType, "application/xml", "text/xml":
+		return parseKMLZones(body)
+	case geoJSONMediaType, "application/json":
+		return parseGeoJSONZones(body)
+	}
+	return nil, fmt.Errorf("must be %s, %s or multipart/form-data, not %s", geoJSONMediaType, kmlMediaType, mediaType)
+}
+
+func parseMultipartZones(body io.Reader, boundary string) ([]importedZone, error) {
+	if boundary == "" {
+		return nil, errors.New("multipart body has no boundary")
+	}
+	reader := multipart.NewReader(body, boundary)
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("the %s file is required", uploadFileField)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() != uploadFileField {
+			part.Close()
+			continue
+		}
+		switch ext := strings.ToLower(path.Ext(part.FileName())); {
+		case ext == ".kml" || strings.HasPrefix(part.Header.Get("Content-Type"), kmlMediaType):
+			return parseKMLZones(part)
+		case ext == ".kmz":
+			return nil, errors.New("KMZ archives are not supported; unzip doc.kml and upload it")
+		}
+		return parseGeoJSONZones(part)
+	}
+}
+
+// parseGeoJSONZones reads a FeatureCollection or a single Feature
+func parseGeoJSONZones(body io.Reader) ([]importedZone, error) {
+	type feature struct {
+		Type       string                 `json:"type"`
+		ID         interface{}            `json:"id"`
+		Geometry   *GeoJSONGeometry       `json:"geometry"`
+		Properties map[string]interface{} `json:"properties"`
+	}
+	var doc struct {
+		feature
+		Features []feature `json:"features"`
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid GeoJSON: %v", err)
+	}
+	features := doc.Features
+	switch doc.Type {
+	case "FeatureCollection":
+	case "Feature":
+		features = []feature{doc.feature}
+	default:
+		return nil, errors.New("must be a GeoJSON FeatureCollection or Feature")
+	}
+
+	zones := make([]importedZone, 0, len(features))
+	for _, f := range features {
+		props := zoneProperties{}
+		for key, value := range f.Properties {
+			switch value := value.(type) {
+			case string:
+				props[key] = value
+			case float64:
+				props[key] = strconv.FormatFloat(value, 'f', -1, 64)
+			}
+		}
+		fallbackID := ""
+		if f.ID != nil {
+			fallbackID = fmt.Sprint(f.ID)
+		}
+		if f.Type != "Feature" || f.Geometry == nil {
+			zones = append(zones, importedZone{ZoneID: props.get("zoneID", "zone_id", "id"), Err: errors.New("geometry: must be a Feature with a geometry")})
+			continue
+		}
+		zones = append(zones, props.zone(fallbackID, *f.Geometry))
+	}
+	return zones, nil
+}
+
+// kmlFile is the subset of KML that zones are exchanged in
+type kmlFile struct {
+	XMLName  xml.Name    `xml:"kml"`
+	Xmlns    string      `xml:"xmlns,attr,omitempty"`
+	Document kmlDocument `xml:"Document"`
+}
+
+type kmlDocument struct {
+	Name       string         `xml:"name,omitempty"`
+	Placemarks []kmlPlacemark `xml:"Placemark"`
+}
+
+type kmlPlacemark struct {
+	ID            string           `xml:"id,attr,omitempty"`
+	Name          string           `xml:"name"`
+	Data          []kmlData        `xml:"ExtendedData>Data"`
+	SimpleData    []kmlSimpleData  `xml:"ExtendedData>SchemaData>SimpleData"`
+	Polygon       *kmlPolygon      `xml:"Polygon"`
+	MultiGeometry *kmlMultiPolygon `xml:"MultiGeometry"`
+	Point         *kmlPoint        `xml:"Point"`
+}
+
+type kmlData struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value"`
+}
+
+// kmlSimpleData is typed data, as QGIS and ogr2ogr write it
+type kmlSimpleData struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:",chardata"`
+}
+
+type kmlPolygon struct {
+	Outer string   `xml:"outerBoundaryIs>LinearRing>coordinates"`
+	Inner []string `xml:"innerBoundaryIs>LinearRing>coordinates"`
+}
+
+type kmlMultiPolygon struct {
+	Polygons []kmlPolygon `xml:"Polygon"`
+}
+
+type kmlPoint struct {
+	Coordinates string `xml:"coordinates"`
+}
+
+// parseKMLZones reads every Placemark, however deeply it sits in folders
+func parseKMLZones(body io.Reader) ([]importedZone, error) {
+	decoder := xml.NewDecoder(body)
+	var zones []importedZone
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid KML: %v", err)
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "Placemark" {
+			continue
+		}
+		var placemark kmlPlacemark
+		if err := decoder.DecodeElement(&placemark, &start); err != nil {
+			return nil, fmt.Errorf("invalid KML: %v", err)
+		}
+		zones = append(zones, placemark.zone())
+	}
+	if zones == nil {
+		return nil, errors.New("must be KML with at least one Placemark")
+	}
+	return zones, nil
+}
+
+func (p kmlPlacemark) zone() importedZone {
+	props := zoneProperties{"name": p.Name}
+	for _, d := range p.Data {
+		props[d.Name] = d.Value
+	}
+	for _, d := range p.SimpleData {
+		props[d.Name] = d.Value
+	}
+
+	var polygons []kmlPolygon
+	var geometry GeoJSONGeometry
+	switch {
+	case p.Polygon != nil:
+		polygons = []kmlPolygon{*p.Polygon}
+	case p.MultiGeometry != nil && len(p.MultiGeometry.Polygons) > 0:
+		polygons = p.MultiGeometry.Polygons
+	case p.Point != nil:
+		positions, err := parseKMLCoordinates(p.Point.Coordinates)
+		if err != nil || len(positions) != 1 {
+			return importedZone{ZoneID: props.get("zoneID", "zone_id"), Err: errors.New("geometry: a Point must have one longitude,latitude position")}
+		}
+		geometry = GeoJSONGeometry{Type: "Point", Coordinates: positions[0]}
+		return props.zone(p.ID, geometry)
+	default:
+		return importedZone{ZoneID: props.get("zoneID", "zone_id"), Name: p.Name, Err: errors.New("geometry: must be a Polygon, a MultiGeometry of Polygons or a Point")}
+	}
+
+	coordinates := make([][][][]float64, 0, len(polygons))
+	for _, polygon := range polygons {
+		rings := make([][][]float64, 0, 1+len(polygon.Inner))
+		for _, text := range append([]string{polygon.Outer}, polygon.Inner...) {
+			ring, err := parseKMLCoordinates(text)
+			if err != nil {
+				return importedZone{ZoneID: props.get("zoneID", "zone_id"), Name: p.Name, Err: fmt.Errorf("geometry: %v", err)}
+			}
+			rings = append(rings, ring)
+		}
+		coordinates = append(coordinates, rings)
+	}
+	geometry = GeoJSONGeometry{Type: "MultiPolygon", Coordinates: coordinates}
+	if len(coordinates) == 1 {
+		geometry = GeoJSONGeometry{Type: "Polygon", Coordinates: coordinates[0]}
+	}
+	return props.zone(p.ID, geometry)
+}
+
+// parseKMLCoordinates reads whitespace-separated longitude,latitude[,altitude] tuples, dropping
+// the altitude
+func parseKMLCoordinates(text string) ([][]float64, error) {
+	var positions [][]float64
+	for _, tuple := range strings.Fields(text) {
+		parts := strings.Split(tuple, ",")
+		if len(parts) < 2 || len(parts) > 3 {
+			return nil, fmt.Errorf("coordinates must be longitude,latitude tuples, not %q", tuple)
+		}
+		lng, lngErr := strconv.ParseFloat(parts[0], 64)
+		lat, latErr := strconv.ParseFloat(parts[1], 64)
+		if lngErr != nil || latErr != nil {
+			return nil, fmt.Errorf("coordinates must be longitude,latitude tuples, not %q", tuple)
+		}
+		positions = append(positions, []float64{lng, lat})
+	}
+	return positions, nil
+}
+
+// prepareZone validates an imported zone's geometry beyond what the API checks, simplifies it and
+// returns the zone as it would be created, with the vertex counts before and after
+func prepareZone(z importedZone, actor string, tolerance float64) (CreateZoneRequest, int, int, []string) {
+	req := CreateZoneRequest{ZoneID: z.ZoneID, Name: z.Name, Geometry: &z.Geometry, RiskLevel: z.RiskLevel, Hours: z.Hours, Actor: actor}
+	if z.Err != nil {
+		return req, 0, 0, []string{z.Err.Error()}
+	}
+	if z.Name == "" {
+		req.Name = z.ZoneID
+	}
+
+	var problems []string
+	polygons, _, err := parseZoneGeometry(z.Geometry)
+	if err != nil {
+		problems = append(problems, "geometry: "+err.Error())
+	}
+	before, after := 0, 0
+	if err == nil && polygons != nil {
+		simplified := make([]zonePolygon, 0, len(polygons))
+		for _, polygon := range polygons {
+			rings := make(zonePolygon, 0, len(polygon))
+			for _, ring := range polygon {
+				before += len(ring)
+				ring = simplifyRing(ring, tolerance)
+				after += len(ring)
+				rings = append(rings, ring)
+			}
+			simplified = append(simplified, rings)
+		}
+		for i, polygon := range simplified {
+			if problem := polygonProblem(polygon); problem != "" {
+				problems = append(problems, fmt.Sprintf("geometry: polygon %d %s", i+1, problem))
+			}
+		}
+		geometry := polygonGeometry(simplified)
+		req.Geometry = &geometry
+	}
+	for _, fe := range req.Validate() {
+		if fe.Field != "geometry" || len(problems) == 0 {
+			problems = append(problems, fe.Field+": "+fe.Message)
+		}
+	}
+	return req, before, after, problems
+}
+
+// polygonProblem describes what makes a polygon unusable as a zone: a ring whose edges cross, a
+// ring with no area, or a hole that crosses the outer ring
+func polygonProblem(polygon zonePolygon) string {
+	for i, ring := range polygon {
+		name := "outer ring"
+		if i > 0 {
+			name = fmt.Sprintf("hole %d", i)
+		}
+		if ring.crosses(ring) {
+			return name + " crosses itself"
+		}
+		if math.Abs(ringArea(ring)) < 1e-12 {
+			return name + " has no area"
+		}
+		if i > 0 && ring.crosses(polygon[0]) {
+			return name + " crosses the outer ring"
+		}
+	}
+	return ""
+}
+
+// ringArea is the ring's signed area in square degrees, by the shoelace formula
+func ringArea(ring zoneRing) float64 {
+	area := 0.0
+	for i := 1; i < len(ring); i++ {
+		area += ring[i-1][0]*ring[i][1] - ring[i][0]*ring[i-1][1]
+	}
+	return area / 2
+}
+
+// simplifyRing drops vertices closer than tolerance metres to the outline the remaining ones
+// draw, by Douglas-Peucker on an equirectangular projection around the ring. A ring that would
+// fall below four positions is kept as it is.
+func simplifyRing(ring zoneRing, tolerance float64) zoneRing {
+	if tolerance <= 0 || len(ring) <= 4 {
+		return ring
+	}
+	kx, ky := 111320*math.Cos(ring[0][1]*math.Pi/180), 110540.0
+	distance := func(p, a, b [2]float64) float64 {
+		px, py := (p[0]-a[0])*kx, (p[1]-a[1])*ky
+		bx, by := (b[0]-a[0])*kx, (b[1]-a[1])*ky
+		length := bx*bx + by*by
+		if length == 0 {
+			return math.Hypot(px, py)
+		}
+		t := math.Max(0, math.Min(1, (px*bx+py*by)/length))
+		return math.Hypot(px-t*bx, py-t*by)
+	}
+
+	keep := make([]bool, len(ring))
+	var simplify func(from, to int)
+	simplify = func(from, to int) {
+		farthest, worst := -1, tolerance
+		for i := from + 1; i < to; i++ {
+			if d := distance(ring[i], ring[from], ring[to]); d > worst {
+				farthest, worst = i, d
+			}
+		}
+		if farthest >= 0 {
+			keep[farthest] = true
+			simplify(from, farthest)
+			simplify(farthest, to)
+		}
+	}
+	// The ring starts and ends on the same vertex, so anchor it on the vertex farthest from there
+	far := 1
+	for i := range ring {
+		if distance(ring[i], ring[0], ring[0]) > distance(ring[far], ring[0], ring[0]) {
+			far = i
+		}
+	}
+	keep[0], keep[far], keep[len(ring)-1] = true, true, true
+	simplify(0, far)
+	simplify(far, len(ring)-1)
+
+	simplified := make(zoneRing, 0, len(ring))
+	for i, p := range ring {
+		if keep[i] {
+			simplified = append(simplified, p)
+		}
+	}
+	if len(simplified) < 4 {
+		return ring
+	}
+	return simplified
+}
+
+// polygonGeometry writes rings back as a GeoJSON Polygon, or a MultiPolygon for several
+func polygonGeometry(polygons []zonePolygon) GeoJSONGeometry {
+	coordinates := make([][][][]float64, 0, len(polygons))
+	for _, polygon := range polygons {
+		rings := make([][][]float64, 0, len(polygon))
+		for _, ring := range polygon {
+			positions := make([][]float64, 0, len(ring))
+			for _, p := range ring {
+				positions = append(positions, []float64{p[0], p[1]})
+			}
+			rings = append(rings, positions)
+		}
+		coordinates = append(coordinates, rings)
+	}
+	if len(coordinates) == 1 {
+		return GeoJSONGeometry{Type: "Polygon", Coordinates: coordinates[0]}
+	}
+	return GeoJSONGeometry{Type: "MultiPolygon", Coordinates: coordinates}
+}
+
+// planZoneImport validates every zone and decides what to do with it against the zones on the
+// ledger, without writing anything. Rows that pass are returned with their requests.
+func planZoneImport(zones []importedZone, existing []ZoneDocument, req ZoneImportRequest) (ZoneImportReport, map[int]CreateZoneRequest) {
+	report := ZoneImportReport{DryRun: req.DryRun, Total: len(zones), Rows: make([]ZoneImportRow, len(zones))}
+	current := newGeofenceIndex()
+	current.replace(existing)
+	imported := newGeofenceIndex()
+	firstRow := map[string]int{}
+	valid := map[int]CreateZoneRequest{}
+
+	for i, z := range zones {
+		row := &report.Rows[i]
+		row.Row, row.ZoneID, row.Name = i+1, z.ZoneID, z.Name
+		create, before, after, problems := prepareZone(z, req.Actor, req.Simplify)
+		row.Name, row.Errors = create.Name, problems
+		row.Vertices = after
+		if after < before {
+			row.SimplifiedFrom = before
+		}
+		if first, ok := firstRow[z.ZoneID]; ok && z.ZoneID != "" {
+			row.Errors = append(row.Errors, fmt.Sprintf("zoneID: repeats row %d", first))
+		} else {
+			firstRow[z.ZoneID] = row.Row
+		}
+		if len(row.Errors) > 0 {
+			row.Status = zoneImportInvalid
+			continue
+		}
+
+		geometryJSON, _ := json.Marshal(create.Geometry)
+		area, err := newIndexedZone(ZoneDocument{ZoneID: create.ZoneID, Name: create.Name, Geometry: string(geometryJSON), RiskLevel: create.RiskLevel})
+		if err != nil {
+			row.Status, row.Errors = zoneImportInvalid, []string{"geometry: " + err.Error()}
+			continue
+		}
+		for _, other := range append(current.overlapping(area), imported.overlapping(area)...) {
+			if other.ZoneID != create.ZoneID {
+				row.Overlaps = append(row.Overlaps, other.ZoneID)
+			}
+		}
+		if req.RejectOverlaps && len(row.Overlaps) > 0 {
+			row.Status, row.Errors = zoneImportInvalid, []string{"geometry: overlaps " + strings.Join(row.Overlaps, ", ")}
+			continue
+		}
+		imported.put(area)
+
+		_, exists := current.zones[create.ZoneID]
+		switch {
+		case exists && !req.Replace:
+			row.Status = zoneImportExists
+			continue
+		case exists:
+			row.Status = zoneImportUpdated
+		default:
+			row.Status = zoneImportCreated
+		}
+		valid[i] = create
+	}
+	return report, valid
+}
+
+// ImportZones creates or updates zones from a GIS file. Each zone is its own transaction, so a zone
+// that fails leaves the others written.
+func (s ledgerService) ImportZones(ctx context.Context, zones []importedZone, req ZoneImportRequest) (ZoneImportReport, error) {
+	if errs := req.Validate(); len(errs) > 0 {
+		return ZoneImportReport{}, errs
+	}
+	if len(zones) == 0 {
+		return ZoneImportReport{}, ValidationErrors{{Field: uploadFileField, Message: "must contain at least one zone"}}
+	}
+	if len(zones) > zoneImport.maxFeatures {
+		return ZoneImportReport{}, ValidationErrors{{Field: uploadFileField, Message: fmt.Sprintf("must contain at most %d zones", zoneImport.maxFeatures)}}
+	}
+	existing, err := s.zoneDocuments(ctx)
+	if err != nil {
+		return ZoneImportReport{}, err
+	}
+
+	report, valid := planZoneImport(zones, existing, req)
+	if !req.DryRun {
+		var wg sync.WaitGroup
+		slots := make(chan struct{}, zoneImport.concurrency)
+		for i, create := range valid {
+			wg.Add(1)
+			slots <- struct{}{}
+			go func() {
+				defer func() { <-slots; wg.Done() }()
+				row := &report.Rows[i]
+				var result *TransactionResult
+				var err error
+				if row.Status == zoneImportUpdated {
+					result, err = s.UpdateZone(ctx, create.ZoneID, UpdateZoneRequest{Name: create.Name, Geometry: create.Geometry, RiskLevel: create.RiskLevel, Hours: create.Hours, Actor: create.Actor})
+				} else {
+					result, err = s.CreateZone(ctx, create)
+				}
+				if err != nil {
+					logWithContext(ctx, "Zone import of %s failed: %v", create.ZoneID, err)
+					row.Status, row.Errors = zoneImportFailed, []string{translateFabricError(err).Message}
+					return
+				}
+				row.TxID = result.TxID
+			}()
+		}
+		wg.Wait()
+	}
+
+	for _, row := range report.Rows {
+		switch row.Status {
+		case zoneImportCreated:
+			report.Created++
+		case zoneImportUpdated:
+			report.Updated++
+		case zoneImportExists:
+			report.Existing++
+		case zoneImportInvalid:
+			report.Invalid++
+		case zoneImportFailed:
+			report.Failed++
+		}
+	}
+	return report, nil
+}
+
+// zoneFeatureProperties are the attributes exported with each zone, in the spelling the importer
+// reads back
+func zoneFeatureProperties(z Zone) map[string]interface{} {
+	props := map[string]interface{}{
+		"zoneID":    z.ZoneID,
+		"name":      z.Name,
+		"riskLevel": z.RiskLevel,
+		"updatedBy": z.UpdatedBy,
+		"updatedAt": z.UpdatedAt,
+	}
+	if z.Hours != nil {
+		props["hoursStart"], props["hoursEnd"], props["timezone"] = z.Hours.Start, z.Hours.End, z.Hours.Timezone
+	}
+	if z.Geometry.Type == "Circle" {
+		props["radius"] = z.Geometry.Radius
+	}
+	return props
+}
+
+// zonesGeoJSON writes zones as a FeatureCollection. GeoJSON has no circles, so a circular zone is a
+// Point with a radius property in metres.
+func zonesGeoJSON(zones []Zone) ([]byte, error) {
+	features := make([]gin.H, 0, len(zones))
+	for _, z := range zones {
+		geometry := gin.H{"type": z.Geometry.Type, "coordinates": z.Geometry.Coordinates}
+		if z.Geometry.Type == "Circle" {
+			geometry["type"] = "Point"
+		}
+		features = append(features, gin.H{"type": "Feature", "id": z.ZoneID, "geometry": geometry, "properties": zoneFeatureProperties(z)})
+	}
+	return json.MarshalIndent(gin.H{"type": "FeatureCollection", "features": features}, "", "  ")
+}
+
+// zonesKML writes zones as KML placemarks, with their attributes as ExtendedData and circular
+// zones as a Point with a radius
+func zonesKML(zones []Zone) ([]byte, error) {
+	file := kmlFile{Xmlns: kmlNamespace, Document: kmlDocument{Name: "Geofence zones"}}
+	for _, z := range zones {
+		placemark := kmlPlacemark{ID: z.ZoneID, Name: z.Name}
+		props := zoneFeatureProperties(z)
+		for _, key := range []string{"zoneID", "riskLevel", "hoursStart", "hoursEnd", "timezone", "radius", "updatedBy", "updatedAt"} {
+			if value, ok := props[key]; ok {
+				placemark.Data = append(placemark.Data, kmlData{Name: key, Value: fmt.Sprint(value)})
+			}
+		}
+
+		polygons, circle, err := parseZoneGeometry(z.Geometry)
+		if err != nil {
+			return nil, fmt.Errorf("zone %s geometry %w", z.ZoneID, err)
+		}
+		if circle != nil {
+			placemark.Point = &kmlPoint{Coordinates: kmlCoordinates(zoneRing{{circle.lng, circle.lat}})}
+		}
+		kmlPolygons := make([]kmlPolygon, 0, len(polygons))
+		for _, polygon := range polygons {
+			p := kmlPolygon{Outer: kmlCoordinates(polygon[0])}
+			for _, hole := range polygon[1:] {
+				p.Inner = append(p.Inner, kmlCoordinates(hole))
+			}
+			kmlPolygons = append(kmlPolygons, p)
+		}
+		switch {
+		case len(kmlPolygons) == 1:
+			placemark.Polygon = &kmlPolygons[0]
+		case len(kmlPolygons) > 1:
+			placemark.MultiGeometry = &kmlMultiPolygon{Polygons: kmlPolygons}
+		}
+		file.Document.Placemarks = append(file.Document.Placemarks, placemark)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	encoder := xml.NewEncoder(&buf)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(file); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func kmlCoordinates(ring zoneRing) string {
+	tuples := make([]string, 0, len(ring))
+	for _, p := range ring {
+		tuples = append(tuples, strconv.FormatFloat(p[0], 'f', -1, 64)+","+strconv.FormatFloat(p[1], 'f', -1, 64))
+	}
+	return strings.Join(tuples, " ")
+}
+
+// importZones creates zones from a GeoJSON or KML file exported from GIS tooling and reports the
+// outcome of each feature
+func importZones(c *gin.Context) {
+	var req ZoneImportRequest
+	if !bindQuery(c, &req) {
+		return
+	}
+	tooLarge := fmt.Sprintf("The zone file must not exceed %d bytes", zoneImport.maxBytes)
+	if c.Request.ContentLength > zoneImport.maxBytes {
+		respondError(c, http.StatusRequestEntityTooLarge, errCodePayloadTooLarge, tooLarge)
+		return
+	}
+	body := http.MaxBytesReader(c.Writer, c.Request.Body, zoneImport.maxBytes)
+
+	zones, err := parseZoneFile(c.GetHeader("Content-Type"), body)
+	if err != nil {
+		var maxBytes *http.MaxBytesError
+		if errors.As(err, &maxBytes) {
+			respondError(c, http.StatusRequestEntityTooLarge, errCodePayloadTooLarge, tooLarge)
+			return
+		}
+		respondValidationErrors(c, ValidationErrors{{Field: uploadFileField, Message: err.Error()}})
+		return
+	}
+
+	report, err := ledger.ImportZones(c.Request.Context(), zones, req)
+	if err != nil {
+		respondServiceError(c, "Failed to import zones", err)
+		return
+	}
+	logWithContext(c.Request.Context(), "Zone import: %d created, %d updated, %d existing, %d invalid, %d failed of %d (dry run %t)",
+		report.Created, report.Updated, report.Existing, report.Invalid, report.Failed, report.Total, report.DryRun)
+	respondData(c, http.StatusOK, report)
+}
+
+// exportZones downloads every zone as GeoJSON or KML, in the shape importZones reads back
+func exportZones(c *gin.Context) {
+	var req ZoneExportRequest
+	if !bindQuery(c, &req) {
+		return
+	}
+	zones, err := ledger.ListZones(c.Request.Context())
+	if err != nil {
+		respondServiceError(c, "Failed to export zones", err)
+		return
+	}
+
+	format, contentType, encode := "geojson", geoJSONMediaType, zonesGeoJSON
+	if req.Format == "kml" {
+		format, contentType, encode = "kml", kmlMediaType, zonesKML
+	}
+	data, err := encode(zones)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	filename := fmt.Sprintf("zones-%s.%s", time.Now().UTC().Format("20060102T150405Z"), format)
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	c.Data(http.StatusOK, contentType, data)
+}
@@ -0,0 +1,149 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const testZonesGeoJSON = `{
+  "type": "FeatureCollection",
+  "features": [
+    {"type": "Feature", "properties": {"zoneID": "ZONE-FALLS", "name": "Elephant Falls", "riskLevel": "High", "hoursStart": "18:00", "hoursEnd": "06:00"},
+     "geometry": {"type": "Polygon", "coordinates": [[[91.80, 25.50, 1500], [91.82, 25.50, 1500], [91.82, 25.52, 1500], [91.80, 25.52, 1500], [91.80, 25.50, 1500]]]}},
+    {"type": "Feature", "id": "ZONE-PEAK", "properties": {"name": "Shillong Peak", "risk_level": "medium", "radius": 800},
+     "geometry": {"type": "Point", "coordinates": [91.81, 25.51]}},
+    {"type": "Feature", "properties": {"zoneID": "ZONE-BOWTIE", "name": "Bowtie", "riskLevel": "low"},
+     "geometry": {"type": "Polygon", "coordinates": [[[91.9, 25.6], [91.92, 25.62], [91.92, 25.6], [91.9, 25.62], [91.9, 25.6]]]}},
+    {"type": "Feature", "properties": {"zoneID": "ZONE-FALLS", "name": "Again", "riskLevel": "low"},
+     "geometry": {"type": "Polygon", "coordinates": [[[92.0, 25.0], [92.1, 25.0], [92.1, 25.1], [92.0, 25.0]]]}}
+  ]
+}`
+
+const testZonesKML = `<?xml version="1.0" encoding="UTF-8"?>
+<kml xmlns="http://www.opengis.net/kml/2.2"><Document><Folder><name>East Khasi Hills</name>
+  <Placemark id="ZONE-LAKE"><name>Umiam Lake</name>
+    <ExtendedData><SchemaData schemaUrl="#zones"><SimpleData name="riskLevel">restricted</SimpleData></SchemaData></ExtendedData>
+    <Polygon><outerBoundaryIs><LinearRing><coordinates>
+      91.88,25.65,0 91.90,25.65,0 91.90,25.67,0 91.88,25.67,0 91.88,25.65,0
+    </coordinates></LinearRing></outerBoundaryIs></Polygon>
+  </Placemark>
+  <Placemark><name>No geometry</name></Placemark>
+</Folder></Document></kml>`
+
+func TestParseZoneFiles(t *testing.T) {
+	zones, err := parseZoneFile("application/geo+json", strings.NewReader(testZonesGeoJSON))
+	if err != nil || len(zones) != 4 {
+		t.Fatalf("expected four GeoJSON features, got %d, %v", len(zones), err)
+	}
+	if z := zones[0]; z.RiskLevel != "high" || z.Hours == nil || z.Hours.Start != "18:00" {
+		t.Errorf("expected the properties read, got %+v", z)
+	}
+	if z := zones[1]; z.ZoneID != "ZONE-PEAK" || z.Geometry.Type != "Circle" || z.Geometry.Radius != 800 {
+		t.Errorf("expected a Point with a radius read as a circle, got %+v", z)
+	}
+
+	zones, err = parseZoneFile(kmlMediaType, strings.NewReader(testZonesKML))
+	if err != nil || len(zones) != 2 {
+		t.Fatalf("expected two placemarks from inside the folder, got %d, %v", len(zones), err)
+	}
+	if z := zones[0]; z.ZoneID != "ZONE-LAKE" || z.Name != "Umiam Lake" || z.RiskLevel != "restricted" || z.Geometry.Type != "Polygon" || z.Err != nil {
+		t.Errorf("expected the placemark read as a zone, got %+v", z)
+	}
+	if zones[1].Err == nil {
+		t.Error("expected a placemark without geometry reported")
+	}
+
+	if _, err := parseZoneFile("text/csv", strings.NewReader("")); err == nil {
+		t.Error("expected CSV rejected")
+	}
+}
+
+func TestPlanZoneImport(t *testing.T) {
+	zones, _ := parseZoneFile("application/json", strings.NewReader(testZonesGeoJSON))
+	lake, _ := parseZoneFile(kmlMediaType, strings.NewReader(testZonesKML))
+	zones = append(zones, lake[0])
+	existing := []ZoneDocument{{
+		ZoneID: "ZONE-LAKE", Name: "Umiam Lake", RiskLevel: "high",
+		Geometry: `{"type":"Polygon","coordinates":[[[91.88,25.65],[91.9,25.65],[91.9,25.67],[91.88,25.67],[91.88,25.65]]]}`,
+	}}
+
+	report, valid := planZoneImport(zones, existing, ZoneImportRequest{Actor: "gis_team"})
+	statuses := make([]string, 0, len(report.Rows))
+	for _, row := range report.Rows {
+		statuses = append(statuses, row.Status)
+	}
+	want := []string{zoneImportCreated, zoneImportCreated, zoneImportInvalid, zoneImportInvalid, zoneImportExists}
+	if !slices.Equal(statuses, want) || len(valid) != 2 {
+		t.Fatalf("expected %v, got %v (%+v)", want, statuses, report.Rows)
+	}
+	if row := report.Rows[1]; !slices.Equal(row.Overlaps, []string{"ZONE-FALLS"}) {
+		t.Errorf("expected the peak to overlap the falls, got %v", row.Overlaps)
+	}
+	if row := report.Rows[2]; !strings.Contains(strings.Join(row.Errors, ";"), "crosses itself") {
+		t.Errorf("expected the bowtie rejected as self-crossing, got %v", row.Errors)
+	}
+	if row := report.Rows[3]; !strings.Contains(strings.Join(row.Errors, ";"), "repeats row 1") {
+		t.Errorf("expected the repeated zone ID rejected, got %v", row.Errors)
+	}
+	if coordinates, _ := json.Marshal(valid[0].Geometry.Coordinates); bytes.Contains(coordinates, []byte("1500")) {
+		t.Errorf("expected altitudes dropped, got %s", coordinates)
+	}
+
+	report, _ = planZoneImport(zones, existing, ZoneImportRequest{Actor: "gis_team", Replace: true, RejectOverlaps: true})
+	if report.Rows[1].Status != zoneImportInvalid || report.Rows[4].Status != zoneImportUpdated {
+		t.Errorf("expected the overlap refused and the existing zone updated, got %+v", report.Rows)
+	}
+}
+
+func TestSimplifyRing(t *testing.T) {
+	// A square of about 1km with a 2m bump on every edge
+	ring := zoneRing{{91.80, 25.50}, {91.805, 25.50002}, {91.81, 25.50}, {91.81, 25.505}, {91.81, 25.51}, {91.805, 25.51}, {91.80, 25.51}, {91.80, 25.505}, {91.80, 25.50}}
+	simplified := simplifyRing(ring, 10)
+	if len(simplified) != 5 || simplified[0] != simplified[len(simplified)-1] {
+		t.Fatalf("expected the square's corners kept, got %v", simplified)
+	}
+	if kept := simplifyRing(ring, 1); len(kept) != len(ring)-3 {
+		t.Errorf("expected only the collinear midpoints dropped at 1m, got %v", kept)
+	}
+	if got := simplifyRing(ring, 0); len(got) != len(ring) {
+		t.Error("expected no simplification without a tolerance")
+	}
+}
+
+func TestZoneExportRoundTrip(t *testing.T) {
+	zones := []Zone{
+		{ZoneID: "ZONE-LAKE", Name: "Umiam Lake", RiskLevel: "restricted", Hours: &ZoneHours{Start: "18:00", End: "06:00", Timezone: "Asia/Kolkata"},
+			Geometry: GeoJSONGeometry{Type: "Polygon", Coordinates: [][][]float64{{{91.88, 25.65}, {91.9, 25.65}, {91.9, 25.67}, {91.88, 25.67}, {91.88, 25.65}}}}},
+		{ZoneID: "ZONE-PEAK", Name: "Shillong Peak", RiskLevel: "medium", Geometry: GeoJSONGeometry{Type: "Circle", Coordinates: []float64{91.81, 25.51}, Radius: 800}},
+	}
+	for _, format := range []struct {
+		name, mediaType string
+		encode          func([]Zone) ([]byte, error)
+	}{{"geojson", geoJSONMediaType, zonesGeoJSON}, {"kml", kmlMediaType, zonesKML}} {
+		t.Run(format.name, func(t *testing.T) {
+			data, err := format.encode(zones)
+			if err != nil {
+				t.Fatal(err)
+			}
+			imported, err := parseZoneFile(format.mediaType, bytes.NewReader(data))
+			if err != nil || len(imported) != 2 {
+				t.Fatalf("expected the export read back, got %d, %v", len(imported), err)
+			}
+			report, _ := planZoneImport(imported, nil, ZoneImportRequest{Actor: "gis_team"})
+			for _, row := range report.Rows {
+				if row.Status != zoneImportCreated {
+					t.Errorf("expected %s importable, got %+v", row.ZoneID, row)
+				}
+			}
+			if lake := imported[0]; lake.Hours == nil || lake.Hours.Timezone != "Asia/Kolkata" || lake.RiskLevel != "restricted" {
+				t.Errorf("expected the attributes kept, got %+v", lake)
+			}
+			if peak := imported[1]; peak.Geometry.Type != "Circle" || peak.Geometry.Radius != 800 {
+				t.Errorf("expected the circle kept, got %+v", peak.Geometry)
+			}
+		})
+	}
+}
@@ -0,0 +1,483 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	errCodeZoneRiskDisabled = "ZONE_RISK_DISABLED"
+
+	// zoneRiskRaisedBy is ZoneMatch.RaisedBy when a zone's risk score raised its level
+	zoneRiskRaisedBy   = "incident_history"
+	maxZoneScoresPerTx = 500
+	// minZoneRiskAreaKm2 keeps a single incident from saturating a zone the size of a building
+	minZoneRiskAreaKm2 = 1.0
+)
+
+// zoneRiskWeights weigh incidents by severity; unclassified incidents count as medium and SOS
+// alerts as critical
+var zoneRiskWeights = map[string]float64{"low": 0.25, "medium": 0.5, "high": 0.75, "critical": 1, "": 0.5, "sos": 1}
+
+// ZoneRiskScore is a zone's risk from the incidents and SOS alerts inside it, from 0 to 1, and the
+// risk level that score maps to
+type ZoneRiskScore struct {
+	Score     float64 `json:"score"`
+	Level     string  `json:"level"`
+	Incidents int     `json:"incidents"`
+	ScoredAt  string  `json:"scored_at"`
+}
+
+// ZoneRisk is a zone as the risk API reports it: the level set for the zone, its score, and the
+// level in force now after the score and any weather alert
+type ZoneRisk struct {
+	ZoneID    string         `json:"zone_id"`
+	Name      string         `json:"name"`
+	BaseLevel string         `json:"base_level"`
+	RiskLevel string         `json:"risk_level"`
+	RaisedBy  string         `json:"raised_by,omitempty"`
+	Active    bool           `json:"active"`
+	Score     *ZoneRiskScore `json:"score,omitempty"`
+}
+
+// ZoneRiskRequest filters the risk list to the zones containing a position, or scoring at least
+// min_score
+type ZoneRiskRequest struct {
+	Latitude  *float64 `form:"latitude"`
+	Longitude *float64 `form:"longitude"`
+	MinScore  float64  `form:"min_score"`
+}
+
+func (r ZoneRiskRequest) Validate() ValidationErrors {
+	var v fieldValidator
+	if (r.Latitude == nil) != (r.Longitude == nil) {
+		v.add("latitude", "must be given together with longitude")
+	}
+	if r.Latitude != nil && (*r.Latitude < -90 || *r.Latitude > 90) {
+		v.add("latitude", "must be between -90 and 90")
+	}
+	if r.Longitude != nil && (*r.Longitude < -180 || *r.Longitude > 180) {
+		v.add("longitude", "must be between -180 and 180")
+	}
+	if r.MinScore < 0 || r.MinScore > 1 {
+		v.add("min_score", "must be between 0 and 1")
+	}
+	return v.errors
+}
+
+// ZoneRiskRun reports one recomputation: how many zones were scored and how many scores changed
+// enough to be written to the zone registry
+type ZoneRiskRun struct {
+	ComputedAt string   `json:"computed_at"`
+	Scored     int      `json:"scored"`
+	Updated    int      `json:"updated"`
+	TxIDs      []string `json:"tx_ids"`
+}
+
+// zoneScore is one entry of a ScoreZones transaction
+type zoneScore struct {
+	ZoneID          string  `json:"zone_id"`
+	RiskScore       float64 `json:"risk_score"`
+	ScoredRiskLevel string  `json:"scored_risk_level"`
+	ScoredIncidents int     `json:"scored_incidents"`
+	ScoredAt        string  `json:"scored_at"`
+}
+
+// zoneRiskThreshold is the lowest score that maps to a risk level
+type zoneRiskThreshold struct {
+	level string
+	score float64
+}
+
+// locatedRecord is an incident or SOS alert from the off-chain index. Its position is the centre of
+// its ledger geohash cell, so records near a zone's edge may be counted on the wrong side of it.
+type locatedRecord struct {
+	lat, lng float64
+	at       time.Time
+	// weight is the severity weight from zoneRiskWeights
+	weight float64
+}
+
+// zoneRiskScorer periodically rescores every zone from the incident density in the off-chain index.
+// Each record counts its severity weight, halved every ZONE_RISK_HALF_LIFE; the weighted count per
+// km² is mapped onto 0 to 1 so that ZONE_RISK_SATURATION scores 0.5.
+type zoneRiskScorer struct {
+	interval   time.Duration
+	window     time.Duration
+	halfLife   time.Duration
+	saturation float64
+	thresholds []zoneRiskThreshold
+	minChange  float64
+}
+
+var zoneRisk *zoneRiskScorer
+
+// initZoneRisk runs after initOffchainIndex and initGeofence
+func initZoneRisk() {
+	if !getEnvBool("ZONE_RISK_ENABLED", false) {
+		log.Println("📈 ZONE_RISK_ENABLED not set, zone risk scoring disabled")
+		return
+	}
+	if offchain == nil {
+		log.Println("📈 Zone risk scoring needs the off-chain index; INDEX_DATABASE_URL not set, scoring disabled")
+		return
+	}
+	thresholds, err := parseZoneRiskThresholds(getEnv("ZONE_RISK_LEVELS", "medium=0.3,high=0.6"))
+	if err != nil {
+		panic(fmt.Errorf("invalid ZONE_RISK_LEVELS: %w", err))
+	}
+	zoneRisk = &zoneRiskScorer{
+		interval:   getEnvDuration("ZONE_RISK_INTERVAL", time.Hour),
+		window:     getEnvDuration("ZONE_RISK_WINDOW", 90*24*time.Hour),
+		halfLife:   getEnvDuration("ZONE_RISK_HALF_LIFE", 14*24*time.Hour),
+		saturation: getEnvFloat("ZONE_RISK_SATURATION", 2),
+		thresholds: thresholds,
+		minChange:  getEnvFloat("ZONE_RISK_MIN_CHANGE", 0.05),
+	}
+	if zoneRisk.interval <= 0 || zoneRisk.window <= 0 || zoneRisk.halfLife <= 0 || zoneRisk.saturation <= 0 {
+		panic(errors.New("ZONE_RISK_INTERVAL, ZONE_RISK_WINDOW, ZONE_RISK_HALF_LIFE and ZONE_RISK_SATURATION must be positive"))
+	}
+	log.Printf("📈 Scoring zone risk every %s over %s of incidents, half-life %s", zoneRisk.interval, zoneRisk.window, zoneRisk.halfLife)
+}
+
+// parseZoneRiskThresholds reads "medium=0.3,high=0.6". Scores below the lowest threshold are low;
+// each level needs a higher score than the level below it.
+func parseZoneRiskThresholds(value string) ([]zoneRiskThreshold, error) {
+	var thresholds []zoneRiskThreshold
+	for _, item := range splitList(value) {
+		level, raw, _ := strings.Cut(item, "=")
+		level = strings.TrimSpace(level)
+		score, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+		switch {
+		case zoneRiskLevel(level) <= 0:
+			return nil, fmt.Errorf("%q must name medium, high or restricted", item)
+		case err != nil || score <= 0 || score > 1:
+			return nil, fmt.Errorf("the score for %s must be above 0 and at most 1", level)
+		}
+		thresholds = append(thresholds, zoneRiskThreshold{level: level, score: score})
+	}
+	sort.Slice(thresholds, func(i, j int) bool { return zoneRiskLevel(thresholds[i].level) < zoneRiskLevel(thresholds[j].level) })
+	for i := 1; i < len(thresholds); i++ {
+		if thresholds[i].level == thresholds[i-1].level || thresholds[i].score <= thresholds[i-1].score {
+			return nil, fmt.Errorf("%s must be listed once and need a higher score than %s", thresholds[i].level, thresholds[i-1].level)
+		}
+	}
+	return thresholds, nil
+}
+
+// score rates a zone from the records in its bounding box at now
+func (s *zoneRiskScorer) score(z *indexedZone, records []locatedRecord, now time.Time) ZoneRiskScore {
+	weighted, count := 0.0, 0
+	for _, r := range records {
+		if !z.contains(r.lng, r.lat) {
+			continue
+		}
+		age := max(now.Sub(r.at), 0)
+		weighted += r.weight * math.Exp2(-age.Hours()/s.halfLife.Hours())
+		count++
+	}
+	density := weighted / math.Max(z.areaKm2(), minZoneRiskAreaKm2)
+	score := math.Round(density/(density+s.saturation)*100) / 100
+	return ZoneRiskScore{Score: score, Level: thresholdLevel(s.thresholds, score), Incidents: count, ScoredAt: now.UTC().Format(time.RFC3339)}
+}
+
+// thresholdLevel maps a score onto the highest level whose threshold it reaches
+func thresholdLevel(thresholds []zoneRiskThreshold, score float64) string {
+	level := zoneRiskLevels[0]
+	for _, t := range thresholds {
+		if score >= t.score {
+			level = t.level
+		}
+	}
+	return level
+}
+
+// changed reports whether a new score differs enough from the recorded one to be written
+func (s *zoneRiskScorer) changed(previous *ZoneRiskScore, next ZoneRiskScore) bool {
+	if previous == nil {
+		return next.Incidents > 0
+	}
+	return previous.Level != next.Level || math.Abs(previous.Score-next.Score) >= s.minChange
+}
+
+// run rescores the default target's zones every ZONE_RISK_INTERVAL. Each window is claimed, so with
+// several gateway instances only one writes the scores.
+func (s *zoneRiskScorer) run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case tick := <-ticker.C:
+			window := tick.UTC().Truncate(s.interval)
+			if claimed, err := claimEvent(ctx, "zone-risk:"+window.Format(time.RFC3339), s.interval); err != nil || !claimed {
+				continue
+			}
+			if _, err := s.recompute(withTarget(ctx, defaultTarget), tick); err != nil {
+				log.Printf("Failed to score zone risk: %v", err)
+			}
+		}
+	}
+}
+
+// recompute scores every indexed zone and records the changed scores in the zone registry, then
+// applies them to the index rather than waiting for the event
+func (s *zoneRiskScorer) recompute(ctx context.Context, now time.Time) (ZoneRiskRun, error) {
+	run := ZoneRiskRun{ComputedAt: now.UTC().Format(time.RFC3339), TxIDs: []string{}}
+	if geofence.lastSync().IsZero() {
+		return run, errors.New("geofence zones have not been loaded yet")
+	}
+	var updates []zoneScore
+	scored := map[string]ZoneRiskScore{}
+	for _, z := range geofence.snapshot() {
+		records, err := offchain.locatedSince(ctx, z.bbox, now.Add(-s.window))
+		if err != nil {
+			return run, err
+		}
+		score := s.score(z, records, now)
+		run.Scored++
+		if !s.changed(z.zone.Risk, score) {
+			continue
+		}
+		scored[z.zone.ZoneID] = score
+		updates = append(updates, zoneScore{ZoneID: z.zone.ZoneID, RiskScore: score.Score, ScoredRiskLevel: score.Level, ScoredIncidents: score.Incidents, ScoredAt: score.ScoredAt})
+	}
+
+	var errs []error
+	for start := 0; start < len(updates); start += maxZoneScoresPerTx {
+		chunk := updates[start:min(start+maxZoneScoresPerTx, len(updates))]
+		payload, err := json.Marshal(chunk)
+		if err != nil {
+			return run, err
+		}
+		result, err := submitTransaction(ctx, "ScoreZones", string(payload))
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		run.TxIDs = append(run.TxIDs, result.TxID)
+		ids := make([]string, 0, len(chunk))
+		for _, update := range chunk {
+			ids = append(ids, update.ZoneID)
+			geofence.setRisk(update.ZoneID, scored[update.ZoneID])
+		}
+		invalidateDocuments(ctx, ids...)
+		run.Updated += len(chunk)
+	}
+	if run.Updated > 0 {
+		log.Printf("📈 Scored %d zones, %d changed", run.Scored, run.Updated)
+	}
+	return run, errors.Join(errs...)
+}
+
+// locatedSince returns the incidents and SOS alerts located in a box since a time
+func (ix *offchainIndex) locatedSince(ctx context.Context, box bounds, since time.Time) ([]locatedRecord, error) {
+	var f queryFilter
+	f.add("latitude BETWEEN ? AND ?", box.minLat, box.maxLat)
+	f.add("longitude BETWEEN ? AND ?", box.minLng, box.maxLng)
+	f.add("at >= ?", since.UTC().Format(time.RFC3339))
+	rows, err := ix.db.Query(ctx, `SELECT latitude, longitude, extract(epoch FROM at), kind FROM (
+			SELECT latitude, longitude, created_at AS at, severity AS kind FROM incidents WHERE deleted_at IS NULL AND geohash <> ''
+			UNION ALL
+			SELECT latitude, longitude, raised_at AS at, 'sos' AS kind FROM sos_alerts WHERE geohash <> ''
+		) located WHERE `+f.where(), f.args...)
+	if err != nil {
+		return nil, err
+	}
+	records := make([]locatedRecord, 0, len(rows))
+	for _, row := range rows {
+		seconds := row.Float(2)
+		records = append(records, locatedRecord{
+			lat:    row.Float(0),
+			lng:    row.Float(1),
+			at:     time.Unix(int64(seconds), 0),
+			weight: zoneRiskWeights[row.String(3)],
+		})
+	}
+	return records, nil
+}
+
+// areaKm2 measures a zone on a flat projection around each ring, which is accurate enough for zones
+// a few kilometres across
+func (z *indexedZone) areaKm2() float64 {
+	if z.circle != nil {
+		radius := z.circle.radius / 1000
+		return math.Pi * radius * radius
+	}
+	area := 0.0
+	for _, polygon := range z.polygons {
+		for i, ring := range polygon {
+			if i == 0 {
+				area += ring.areaKm2()
+			} else {
+				area -= ring.areaKm2()
+			}
+		}
+	}
+	return math.Max(area, 0)
+}
+
+func (r zoneRing) areaKm2() float64 {
+	if len(r) < 3 {
+		return 0
+	}
+	kmPerLng := 111.32 * math.Cos(r[0][1]*math.Pi/180)
+	project := func(p [2]float64) (float64, float64) {
+		return (p[0] - r[0][0]) * kmPerLng, (p[1] - r[0][1]) * 110.57
+	}
+	sum := 0.0
+	for i := range r {
+		x1, y1 := project(r[i])
+		x2, y2 := project(r[(i+1)%len(r)])
+		sum += x1*y2 - x2*y1
+	}
+	return math.Abs(sum) / 2
+}
+
+// snapshot returns the indexed zones in ID order
+func (g *geofenceIndex) snapshot() []*indexedZone {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	zones := make([]*indexedZone, 0, len(g.zones))
+	for _, id := range sortedKeys(g.zones) {
+		zones = append(zones, g.zones[id])
+	}
+	return zones
+}
+
+// risk returns a zone's recorded score, if it has one
+func (g *geofenceIndex) risk(zoneID string) *ZoneRiskScore {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	if z, ok := g.zones[zoneID]; ok {
+		return z.zone.Risk
+	}
+	return nil
+}
+
+// setRisk replaces a zone's score. The zone is copied, since evaluations may hold the old one.
+func (g *geofenceIndex) setRisk(zoneID string, score ZoneRiskScore) {
+	g.mu.RLock()
+	z, ok := g.zones[zoneID]
+	g.mu.RUnlock()
+	if !ok {
+		return
+	}
+	scored := *z
+	scored.zone.Risk = &score
+	g.put(&scored)
+}
+
+// scoredAt returns the active zone with the highest risk score at a position
+func (g *geofenceIndex) scoredAt(lat, lng float64, at time.Time) (ZoneMatch, bool) {
+	var best ZoneMatch
+	found := false
+	for _, match := range g.evaluate(lat, lng, at).Zones {
+		if match.Active && match.RiskScore > 0 && (!found || match.RiskScore > best.RiskScore) {
+			best, found = match, true
+		}
+	}
+	return best, found
+}
+
+// zoneRiskAt rates a position from 0 to 1 by its riskiest zone: the zone's risk level as a share of
+// the levels, or its incident history score when that is higher, which sets scored
+func zoneRiskAt(lat, lng float64, at time.Time) (risk float64, zoneID, level string, scored bool) {
+	zoneID, level = riskiestZone(lat, lng, at)
+	if level != "" {
+		risk = float64(zoneRiskLevel(level)) / float64(len(zoneRiskLevels)-1)
+	}
+	if geofence != nil {
+		if match, ok := geofence.scoredAt(lat, lng, at); ok && match.RiskScore > risk {
+			return match.RiskScore, match.ZoneID, level, true
+		}
+	}
+	return risk, zoneID, level, false
+}
+
+func (g *geofenceIndex) zoneRisk(z *indexedZone, at time.Time) ZoneRisk {
+	match := g.match(z, at)
+	return ZoneRisk{
+		ZoneID:    z.zone.ZoneID,
+		Name:      z.zone.Name,
+		BaseLevel: z.zone.RiskLevel,
+		RiskLevel: match.RiskLevel,
+		RaisedBy:  match.RaisedBy,
+		Active:    match.Active,
+		Score:     z.zone.Risk,
+	}
+}
+
+// listZoneRisk serves route planners and dashboards from the geofence index, riskiest zones first
+func listZoneRisk(c *gin.Context) {
+	var req ZoneRiskRequest
+	if !bindQuery(c, &req) {
+		return
+	}
+	now := time.Now()
+	risks := []ZoneRisk{}
+	for _, z := range geofence.snapshot() {
+		if req.Latitude != nil && !z.contains(*req.Longitude, *req.Latitude) {
+			continue
+		}
+		risk := geofence.zoneRisk(z, now)
+		if req.MinScore > 0 && (risk.Score == nil || risk.Score.Score < req.MinScore) {
+			continue
+		}
+		risks = append(risks, risk)
+	}
+	scoreOf := func(r ZoneRisk) float64 {
+		if r.Score == nil {
+			return 0
+		}
+		return r.Score.Score
+	}
+	sort.SliceStable(risks, func(i, j int) bool { return scoreOf(risks[i]) > scoreOf(risks[j]) })
+	respondData(c, http.StatusOK, risks)
+}
+
+func getZoneRisk(c *gin.Context) {
+	id, ok := validPathID(c, "id")
+	if !ok {
+		return
+	}
+	for _, z := range geofence.snapshot() {
+		if z.zone.ZoneID == id {
+			respondData(c, http.StatusOK, geofence.zoneRisk(z, time.Now()))
+			return
+		}
+	}
+	respondError(c, http.StatusNotFound, errCodeNotFound, "No geofence zone with this ID")
+}
+
+// recomputeZoneRisk rescores every zone now instead of waiting for ZONE_RISK_INTERVAL
+func recomputeZoneRisk(c *gin.Context) {
+	if zoneRisk == nil {
+		respondError(c, http.StatusServiceUnavailable, errCodeZoneRiskDisabled, "Zone risk scoring is not enabled")
+		return
+	}
+	run, err := zoneRisk.recompute(withTarget(c.Request.Context(), defaultTarget), time.Now())
+	if err != nil {
+		respondServiceError(c, "Failed to score zone risk", err)
+		return
+	}
+	respondData(c, http.StatusOK, run)
+}
@@ -0,0 +1,84 @@
+package gateway
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestZoneRiskScore(t *testing.T) {
+	thresholds, err := parseZoneRiskThresholds("high=0.6,medium=0.3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	scorer := &zoneRiskScorer{halfLife: 14 * 24 * time.Hour, saturation: 2, thresholds: thresholds, minChange: 0.05}
+	// A 1 km radius circle is about 3.14 km²
+	z, err := newIndexedZone(ZoneDocument{ZoneID: "falls", Name: "Elephant Falls", Geometry: `{"type":"Circle","coordinates":[91.82,25.54],"radius":1000}`, RiskLevel: "low"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if area := z.areaKm2(); math.Abs(area-math.Pi) > 0.01 {
+		t.Errorf("expected a circle of π km², got %.3f", area)
+	}
+	now := time.Date(2025, 9, 20, 13, 0, 0, 0, time.UTC)
+
+	records := make([]locatedRecord, 0, 12)
+	for range 10 {
+		records = append(records, locatedRecord{lat: 25.54, lng: 91.82, at: now, weight: zoneRiskWeights["critical"]})
+	}
+	// Outside the zone, and a low incident a half-life ago
+	records = append(records, locatedRecord{lat: 25.60, lng: 91.82, at: now, weight: 1})
+	records = append(records, locatedRecord{lat: 25.541, lng: 91.82, at: now.Add(-14 * 24 * time.Hour), weight: zoneRiskWeights["low"]})
+
+	score := scorer.score(z, records, now)
+	// 10.125 weighted incidents over π km² is a density of 3.22, scoring 3.22 / 5.22
+	if score.Incidents != 11 || score.Score != 0.62 || score.Level != "high" {
+		t.Errorf("unexpected score %+v", score)
+	}
+	if quiet := scorer.score(z, nil, now); quiet.Score != 0 || quiet.Level != "low" || scorer.changed(nil, quiet) {
+		t.Errorf("expected a quiet zone to score nothing and not be written, got %+v", quiet)
+	}
+	if scorer.changed(&ZoneRiskScore{Score: 0.6, Level: "high"}, score) {
+		t.Error("expected a change under ZONE_RISK_MIN_CHANGE at the same level skipped")
+	}
+	if !scorer.changed(&ZoneRiskScore{Score: 0.59, Level: "medium"}, score) {
+		t.Error("expected a level change written")
+	}
+
+	for _, value := range []string{"low=0.1", "medium=0.5,high=0.4", "high=1.5", "medium=0.3,medium=0.4"} {
+		if _, err := parseZoneRiskThresholds(value); err == nil {
+			t.Errorf("expected %q rejected", value)
+		}
+	}
+}
+
+func TestZoneRiskRaisesMatch(t *testing.T) {
+	geofence = newGeofenceIndex()
+	defer func() { geofence = nil }()
+	square := `{"type":"Polygon","coordinates":[[[91.81,25.53],[91.83,25.53],[91.83,25.55],[91.81,25.55],[91.81,25.53]]]}`
+	z, err := newIndexedZone(ZoneDocument{ZoneID: "falls", Name: "Elephant Falls", Geometry: square, RiskLevel: "medium"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 0.02° by 0.02° at 25.5° N is about 2.01 km by 2.21 km
+	if area := z.areaKm2(); math.Abs(area-4.44) > 0.05 {
+		t.Errorf("expected about 4.44 km², got %.3f", area)
+	}
+	geofence.put(z)
+	at := time.Date(2025, 9, 20, 13, 0, 0, 0, time.UTC)
+
+	geofence.setRisk("falls", ZoneRiskScore{Score: 0.25, Level: "low", Incidents: 2})
+	if match := geofence.evaluate(25.54, 91.82, at).Zones[0]; match.RiskLevel != "medium" || match.RaisedBy != "" || match.RiskScore != 0.25 {
+		t.Errorf("expected a low score to leave the zone's level, got %+v", match)
+	}
+	geofence.setRisk("falls", ZoneRiskScore{Score: 0.7, Level: "high", Incidents: 9})
+	if match := geofence.evaluate(25.54, 91.82, at).Zones[0]; match.RiskLevel != "high" || match.RaisedBy != zoneRiskRaisedBy {
+		t.Errorf("expected the score to raise the zone, got %+v", match)
+	}
+	if scored, ok := geofence.scoredAt(25.54, 91.82, at); !ok || scored.RiskScore != 0.7 {
+		t.Errorf("expected the scored zone at the position, got %+v", scored)
+	}
+	if risk := geofence.zoneRisk(geofence.snapshot()[0], at); risk.BaseLevel != "medium" || risk.RiskLevel != "high" || risk.Score.Incidents != 9 {
+		t.Errorf("unexpected zone risk %+v", risk)
+	}
+}
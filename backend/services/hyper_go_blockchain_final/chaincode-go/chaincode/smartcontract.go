@@ -1,8 +1,15 @@
 package chaincode
 
 import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
@@ -31,20 +38,697 @@ type IncidentDocument struct {
 	IncidentSummaryHash string `json:"incident_summary_hash"`
 	CreatedAt           string `json:"created_at"`
 	Reporter            string `json:"reporter"`
+	Status              string `json:"status,omitempty" metadata:",optional"`
+	Severity            string `json:"severity,omitempty" metadata:",optional"`
+	Category            string `json:"category,omitempty" metadata:",optional"`
+	SubjectID           string `json:"subject_id,omitempty" metadata:",optional"`
+	Geohash             string `json:"geohash,omitempty" metadata:",optional"`
+	AcknowledgedAt      string `json:"acknowledged_at,omitempty" metadata:",optional"`
+	ResolvedAt          string `json:"resolved_at,omitempty" metadata:",optional"`
+	MergedInto          string `json:"merged_into,omitempty" metadata:",optional"`
+	OwnerOrg            string `json:"owner_org,omitempty" metadata:",optional"`
 	TxID                string `json:"tx_id"`
 }
 
+// IncidentQueryResult is one page of incidents matching a search
+type IncidentQueryResult struct {
+	Incidents    []*IncidentDocument `json:"incidents"`
+	Bookmark     string              `json:"bookmark"`
+	FetchedCount int32               `json:"fetched_count"`
+}
+
 // EvidenceDocument represents evidence anchored to an incident
 type EvidenceDocument struct {
 	DocType      string `json:"doc_type"`
+	EvidenceID   string `json:"evidence_id,omitempty" metadata:",optional"`
 	EvidenceHash string `json:"evidence_hash"`
 	IncidentID   string `json:"incident_id"`
 	MediaType    string `json:"media_type"`
 	UploadedBy   string `json:"uploaded_by"`
 	CreatedAt    string `json:"created_at"`
+	CID          string `json:"cid,omitempty" metadata:",optional"`
+	// EncryptionKeyRef names the data key the stored file is encrypted with; the hash is of the plaintext
+	EncryptionKeyRef string `json:"encryption_key_ref,omitempty" metadata:",optional"`
+	OwnerOrg         string `json:"owner_org,omitempty" metadata:",optional"`
+	TxID             string `json:"tx_id"`
+}
+
+// EvidenceDerivativeDocument anchors a copy derived from an evidence file, such as a thumbnail or a
+// preview transcode. The evidence document and its hash are left as they are; ParentHash is the
+// evidence hash the copy was made from and Transformation describes how.
+type EvidenceDerivativeDocument struct {
+	DocType        string `json:"doc_type"`
+	DerivativeID   string `json:"derivative_id"`
+	EvidenceID     string `json:"evidence_id"`
+	IncidentID     string `json:"incident_id"`
+	Kind           string `json:"kind"`
+	ParentHash     string `json:"parent_hash"`
+	DerivativeHash string `json:"derivative_hash"`
+	MediaType      string `json:"media_type"`
+	Transformation string `json:"transformation"`
+	// EncryptionKeyRef names the data key the stored copy is encrypted with, as for its evidence
+	EncryptionKeyRef string `json:"encryption_key_ref,omitempty" metadata:",optional"`
+	CreatedBy        string `json:"created_by"`
+	CreatedAt        string `json:"created_at"`
+	OwnerOrg         string `json:"owner_org,omitempty" metadata:",optional"`
+	TxID             string `json:"tx_id"`
+}
+
+// EFIRDocument anchors the hash of an electronic FIR generated for an incident
+type EFIRDocument struct {
+	DocType      string `json:"doc_type"`
+	FIRID        string `json:"fir_id"`
+	IncidentID   string `json:"incident_id"`
+	DocumentHash string `json:"document_hash"`
+	GeneratedBy  string `json:"generated_by"`
+	CreatedAt    string `json:"created_at"`
+	// Set when the FIR was recorded against a number allocated with AllocateFIRNumber
+	FIRNumber     string `json:"fir_number,omitempty" metadata:",optional"`
+	District      string `json:"district,omitempty" metadata:",optional"`
+	PoliceStation string `json:"police_station,omitempty" metadata:",optional"`
+	TxID          string `json:"tx_id"`
+}
+
+// HashAnchor tells whether a hash is anchored as evidence or an e-FIR, and when it was first
+// anchored, without saying which document anchors it
+type HashAnchor struct {
+	Anchored   bool   `json:"anchored"`
+	AnchoredAt string `json:"anchored_at,omitempty" metadata:",optional"`
+}
+
+// TimelineAnnexureDocument anchors the hash of an incident timeline rendered as a court annexure.
+// TimelineDigest commits to the timeline's entries, so the annexure can be checked against a
+// timeline reconstructed later.
+type TimelineAnnexureDocument struct {
+	DocType        string `json:"doc_type"`
+	AnnexureID     string `json:"annexure_id"`
+	IncidentID     string `json:"incident_id"`
+	DocumentHash   string `json:"document_hash"`
+	TimelineDigest string `json:"timeline_digest"`
+	EntryCount     int    `json:"entry_count"`
+	GeneratedBy    string `json:"generated_by"`
+	CreatedAt      string `json:"created_at"`
+	OwnerOrg       string `json:"owner_org,omitempty" metadata:",optional"`
+	TxID           string `json:"tx_id"`
+}
+
+// FIRAllocationDocument reserves a number in a police station's FIR register. Numbers run from 1
+// each year per district and station and are never reused, so an allocation whose FIR was never
+// recorded stays in the register as a visible gap.
+type FIRAllocationDocument struct {
+	DocType       string `json:"doc_type"`
+	FIRID         string `json:"fir_id"`
+	FIRNumber     string `json:"fir_number"`
+	District      string `json:"district"`
+	PoliceStation string `json:"police_station"`
+	Year          int    `json:"year"`
+	Sequence      int    `json:"sequence"`
+	IncidentID    string `json:"incident_id"`
+	Status        string `json:"status"`
+	AllocatedBy   string `json:"allocated_by"`
+	AllocatedAt   string `json:"allocated_at"`
+	RecordedAt    string `json:"recorded_at,omitempty" metadata:",optional"`
+	TxID          string `json:"tx_id"`
+}
+
+// SOSDocument records an SOS raised by a tourist. The location is kept off the ledger; only its hash
+// is anchored so the reported position can be proven later.
+type SOSDocument struct {
+	DocType      string `json:"doc_type"`
+	AlertID      string `json:"alert_id"`
+	DigitalID    string `json:"digital_id"`
+	LocationHash string `json:"location_hash"`
+	PoliceUnit   string `json:"police_unit,omitempty" metadata:",optional"`
+	Geohash      string `json:"geohash,omitempty" metadata:",optional"`
+	RaisedAt     string `json:"raised_at"`
+	OwnerOrg     string `json:"owner_org,omitempty" metadata:",optional"`
+	TxID         string `json:"tx_id"`
+}
+
+// SOSTriggerDocument records how the confirmation window of an SOS triggered from the app ended:
+// cancelled by the tourist, so no alert was raised, or escalated because nobody confirmed or
+// cancelled it in time
+type SOSTriggerDocument struct {
+	DocType     string `json:"doc_type"`
+	TriggerID   string `json:"trigger_id"`
+	AlertID     string `json:"alert_id"`
+	DigitalID   string `json:"digital_id"`
+	Outcome     string `json:"outcome"`
+	TriggeredAt string `json:"triggered_at"`
+	DecidedAt   string `json:"decided_at"`
+	Reason      string `json:"reason,omitempty" metadata:",optional"`
+	RecordedBy  string `json:"recorded_by"`
+	RecordedAt  string `json:"recorded_at"`
+	OwnerOrg    string `json:"owner_org,omitempty" metadata:",optional"`
+	TxID        string `json:"tx_id"`
+}
+
+// AccessGrantDocument lets another organization read one of the grantor's incidents until
+// ExpiresAt. Scopes are incident, evidence or both; EvidenceIDs limits the evidence scope to the
+// listed items, and an empty list covers all of the incident's evidence.
+type AccessGrantDocument struct {
+	DocType     string   `json:"doc_type"`
+	GrantID     string   `json:"grant_id"`
+	GrantorOrg  string   `json:"grantor_org"`
+	GranteeOrg  string   `json:"grantee_org"`
+	IncidentID  string   `json:"incident_id"`
+	Scopes      []string `json:"scopes"`
+	EvidenceIDs []string `json:"evidence_ids,omitempty" metadata:",optional"`
+	Reason      string   `json:"reason"`
+	GrantedBy   string   `json:"granted_by"`
+	GrantedAt   string   `json:"granted_at"`
+	ExpiresAt   string   `json:"expires_at"`
+	RevokedBy   string   `json:"revoked_by,omitempty" metadata:",optional"`
+	RevokedAt   string   `json:"revoked_at,omitempty" metadata:",optional"`
+	TxID        string   `json:"tx_id"`
+}
+
+// LocationAnchorDocument commits to the location pings a tourist's app reported during one anchoring
+// window. Digest is a salted SHA-256 over the pings, so the positions themselves stay off the ledger.
+type LocationAnchorDocument struct {
+	DocType     string `json:"doc_type"`
+	AnchorID    string `json:"anchor_id"`
+	DigitalID   string `json:"digital_id"`
+	Digest      string `json:"digest"`
+	PingCount   int    `json:"ping_count"`
+	FirstPingAt string `json:"first_ping_at"`
+	LastPingAt  string `json:"last_ping_at"`
+	AnchoredAt  string `json:"anchored_at"`
+	TxID        string `json:"tx_id"`
+}
+
+// SafetyAnchorDocument commits to a tourist's safety score as it stood when a window was anchored.
+// Digest is a SHA-256 over the score with its components.
+type SafetyAnchorDocument struct {
+	DocType    string `json:"doc_type"`
+	AnchorID   string `json:"anchor_id"`
+	DigitalID  string `json:"digital_id"`
+	Score      int    `json:"score"`
+	Level      string `json:"level"`
+	Digest     string `json:"digest"`
+	ComputedAt string `json:"computed_at"`
+	AnchoredAt string `json:"anchored_at"`
+	TxID       string `json:"tx_id"`
+}
+
+// ModelDecisionDocument records what an automated model decided on an alert, so a dispatch it
+// triggered can be explained later. FeatureHash is a SHA-256 over the inputs the model saw, which
+// stay off the ledger; an incident the decision raised has the alert's ID.
+type ModelDecisionDocument struct {
+	DocType      string  `json:"doc_type"`
+	DecisionID   string  `json:"decision_id"`
+	AlertID      string  `json:"alert_id"`
+	AlertType    string  `json:"alert_type"`
+	DigitalID    string  `json:"digital_id"`
+	Model        string  `json:"model"`
+	ModelVersion string  `json:"model_version"`
+	FeatureHash  string  `json:"feature_hash"`
+	Score        float64 `json:"score"`
+	Decision     string  `json:"decision"`
+	DecidedAt    string  `json:"decided_at"`
+	AnchoredAt   string  `json:"anchored_at"`
+	TxID         string  `json:"tx_id"`
+}
+
+// ItineraryAnchorDocument commits to a route a tourist declared. Digest is a SHA-256 over the
+// itinerary's stops, stay windows and dates, which stay off the ledger.
+type ItineraryAnchorDocument struct {
+	DocType    string `json:"doc_type"`
+	AnchorID   string `json:"anchor_id"`
+	DigitalID  string `json:"digital_id"`
+	Digest     string `json:"digest"`
+	StopCount  int    `json:"stop_count"`
+	StartsAt   string `json:"starts_at"`
+	EndsAt     string `json:"ends_at"`
+	AnchoredBy string `json:"anchored_by"`
+	AnchoredAt string `json:"anchored_at"`
+	OwnerOrg   string `json:"owner_org,omitempty" metadata:",optional"`
+	TxID       string `json:"tx_id"`
+}
+
+// CaseAnchorDocument commits to one stage of a missing person case. The case, with the evidence
+// gathered and the search area drawn, stays with the gateway; Digest is the SHA-256 of its snapshot at
+// the stage. Sequence numbers a case's anchors from 1.
+type CaseAnchorDocument struct {
+	DocType    string `json:"doc_type"`
+	AnchorID   string `json:"anchor_id"`
+	IncidentID string `json:"incident_id"`
+	Sequence   int    `json:"sequence"`
+	Stage      string `json:"stage"`
+	Digest     string `json:"digest"`
+	AnchoredBy string `json:"anchored_by"`
+	AnchoredAt string `json:"anchored_at"`
+	OwnerOrg   string `json:"owner_org,omitempty" metadata:",optional"`
+	TxID       string `json:"tx_id"`
+}
+
+// CCTVManifestDocument anchors a CCTV export from a municipal system: the camera, the window it
+// covers and each clip's hash. Every clip is also recorded as evidence on the incident under its
+// EvidenceID; the footage itself stays with the municipal system.
+type CCTVManifestDocument struct {
+	DocType      string     `json:"doc_type"`
+	ManifestID   string     `json:"manifest_id"`
+	IncidentID   string     `json:"incident_id"`
+	Source       string     `json:"source"`
+	CameraID     string     `json:"camera_id"`
+	WindowStart  string     `json:"window_start"`
+	WindowEnd    string     `json:"window_end"`
+	Clips        []CCTVClip `json:"clips"`
+	ManifestHash string     `json:"manifest_hash"`
+	AnchoredBy   string     `json:"anchored_by"`
+	AnchoredAt   string     `json:"anchored_at"`
+	OwnerOrg     string     `json:"owner_org,omitempty" metadata:",optional"`
+	TxID         string     `json:"tx_id"`
+}
+
+// CCTVClip is one clip of a CCTV export
+type CCTVClip struct {
+	ClipID     string `json:"clip_id"`
+	EvidenceID string `json:"evidence_id"`
+	Hash       string `json:"hash"`
+	StartedAt  string `json:"started_at"`
+	EndedAt    string `json:"ended_at"`
+}
+
+// CallDocument anchors a call to the emergency helpline and the incident it opened. The caller's
+// number is only kept as a hash salted with the call ID; the recording stays with the telephony
+// provider, and its hash is also recorded as evidence under EvidenceID.
+type CallDocument struct {
+	DocType         string `json:"doc_type"`
+	CallID          string `json:"call_id"`
+	Provider        string `json:"provider"`
+	IncidentID      string `json:"incident_id"`
+	CallerHash      string `json:"caller_hash"`
+	UnitID          string `json:"unit_id,omitempty" metadata:",optional"`
+	Outcome         string `json:"outcome"`
+	StartedAt       string `json:"started_at"`
+	EndedAt         string `json:"ended_at"`
+	DurationSeconds int    `json:"duration_seconds"`
+	RecordingHash   string `json:"recording_hash,omitempty" metadata:",optional"`
+	EvidenceID      string `json:"evidence_id,omitempty" metadata:",optional"`
+	AnchoredBy      string `json:"anchored_by"`
+	AnchoredAt      string `json:"anchored_at"`
+	OwnerOrg        string `json:"owner_org,omitempty" metadata:",optional"`
+	TxID            string `json:"tx_id"`
+}
+
+// ERSSExchangeDocument records an SOS or incident exchanged with the national 112 Emergency
+// Response Support System. PayloadHash is a SHA-256 over the event as sent or received, which stays
+// off the ledger; an outbound push that failed can be recorded again once it is delivered.
+type ERSSExchangeDocument struct {
+	DocType     string `json:"doc_type"`
+	ExchangeID  string `json:"exchange_id"`
+	Direction   string `json:"direction"`
+	ERSSEventID string `json:"erss_event_id"`
+	SubjectType string `json:"subject_type"`
+	SubjectID   string `json:"subject_id"`
+	PayloadHash string `json:"payload_hash"`
+	Status      string `json:"status"`
+	Attempts    int    `json:"attempts"`
+	ExchangedAt string `json:"exchanged_at"`
+	RecordedBy  string `json:"recorded_by"`
+	OwnerOrg    string `json:"owner_org,omitempty" metadata:",optional"`
+	TxID        string `json:"tx_id"`
+}
+
+// DeviceDocument registers a safety band by its serial. AttestationKey is the base64 Ed25519
+// public key the band signs its telemetry with; DigitalID and BindingID name the tourist wearing it
+// and the binding that paired them, and are empty while the band is unbound.
+type DeviceDocument struct {
+	DocType        string `json:"doc_type"`
+	DeviceID       string `json:"device_id"`
+	Type           string `json:"type"`
+	AttestationKey string `json:"attestation_key,omitempty" metadata:",optional"`
+	DigitalID      string `json:"digital_id,omitempty" metadata:",optional"`
+	BindingID      string `json:"binding_id,omitempty" metadata:",optional"`
+	Bindings       int    `json:"bindings"`
+	RegisteredBy   string `json:"registered_by"`
+	RegisteredAt   string `json:"registered_at"`
+	UpdatedAt      string `json:"updated_at"`
+	OwnerOrg       string `json:"owner_org,omitempty" metadata:",optional"`
+	TxID           string `json:"tx_id"`
+}
+
+// DeviceBindingDocument records a band being bound to or unbound from a tourist. Sequence numbers a
+// band's bindings from 1, so the tourist wearing it at any time can be read back from the ledger.
+type DeviceBindingDocument struct {
+	DocType   string `json:"doc_type"`
+	BindingID string `json:"binding_id"`
+	DeviceID  string `json:"device_id"`
+	Sequence  int    `json:"sequence"`
+	Action    string `json:"action"`
+	DigitalID string `json:"digital_id"`
+	Actor     string `json:"actor"`
+	At        string `json:"at"`
+	OwnerOrg  string `json:"owner_org,omitempty" metadata:",optional"`
+	TxID      string `json:"tx_id"`
+}
+
+// StayDocument records a tourist's stay at registered accommodation, as reported by the hotel or
+// homestay provider. The guest register entry stays with the provider; RecordHash is its SHA-256, and
+// the property's position is kept only as a geohash.
+type StayDocument struct {
+	DocType      string `json:"doc_type"`
+	StayID       string `json:"stay_id"`
+	DigitalID    string `json:"digital_id"`
+	ProviderID   string `json:"provider_id"`
+	PropertyID   string `json:"property_id"`
+	Geohash      string `json:"geohash"`
+	Status       string `json:"status"`
+	CheckedInAt  string `json:"checked_in_at"`
+	CheckedOutAt string `json:"checked_out_at,omitempty" metadata:",optional"`
+	RecordHash   string `json:"record_hash"`
+	ReportedBy   string `json:"reported_by"`
+	OwnerOrg     string `json:"owner_org,omitempty" metadata:",optional"`
 	TxID         string `json:"tx_id"`
 }
 
+// PermitDocument lets a tourist enter a restricted or trekking zone between ValidFrom and
+// ValidUntil. The application behind it stays with the gateway; the ledger holds the decision.
+type PermitDocument struct {
+	DocType          string `json:"doc_type"`
+	PermitID         string `json:"permit_id"`
+	DigitalID        string `json:"digital_id"`
+	ZoneID           string `json:"zone_id"`
+	Purpose          string `json:"purpose"`
+	ValidFrom        string `json:"valid_from"`
+	ValidUntil       string `json:"valid_until"`
+	Status           string `json:"status"`
+	ApprovedBy       string `json:"approved_by"`
+	IssuedAt         string `json:"issued_at"`
+	RevokedBy        string `json:"revoked_by,omitempty" metadata:",optional"`
+	RevokedAt        string `json:"revoked_at,omitempty" metadata:",optional"`
+	RevocationReason string `json:"revocation_reason,omitempty" metadata:",optional"`
+	OwnerOrg         string `json:"owner_org,omitempty" metadata:",optional"`
+	TxID             string `json:"tx_id"`
+}
+
+// ClaimReferenceDocument links an insurance claim to an incident and the evidence it rests on, as
+// they stood when the claim was registered. ClaimNumberHash is a SHA-256 of the insurer's claim
+// number, which stays off the ledger; EvidenceDigest is a SHA-256 over the evidence IDs and hashes
+// in ID order, one "id:hash" line each.
+type ClaimReferenceDocument struct {
+	DocType             string          `json:"doc_type"`
+	ClaimRefID          string          `json:"claim_ref_id"`
+	IncidentID          string          `json:"incident_id"`
+	DigitalID           string          `json:"digital_id,omitempty" metadata:",optional"`
+	InsurerID           string          `json:"insurer_id"`
+	ClaimNumberHash     string          `json:"claim_number_hash"`
+	IncidentSummaryHash string          `json:"incident_summary_hash"`
+	Evidence            []ClaimEvidence `json:"evidence"`
+	EvidenceDigest      string          `json:"evidence_digest"`
+	RegisteredBy        string          `json:"registered_by"`
+	RegisteredAt        string          `json:"registered_at"`
+	OwnerOrg            string          `json:"owner_org,omitempty" metadata:",optional"`
+	TxID                string          `json:"tx_id"`
+}
+
+// ClaimEvidence is one evidence item a claim references, with its hash at registration
+type ClaimEvidence struct {
+	EvidenceID   string `json:"evidence_id"`
+	EvidenceHash string `json:"evidence_hash"`
+}
+
+// LostFoundItemDocument is an item reported lost by a tourist or handed in as found. The
+// descriptors are what help-center staff search and match on; the photos are SHA-256 hashes of
+// files kept in the evidence store. MatchID is set once the item is matched and returned.
+type LostFoundItemDocument struct {
+	DocType      string           `json:"doc_type"`
+	ItemID       string           `json:"item_id"`
+	Kind         string           `json:"kind"`
+	Category     string           `json:"category"`
+	Description  string           `json:"description"`
+	Color        string           `json:"color,omitempty" metadata:",optional"`
+	Brand        string           `json:"brand,omitempty" metadata:",optional"`
+	LocationName string           `json:"location_name"`
+	Geohash      string           `json:"geohash,omitempty" metadata:",optional"`
+	OccurredAt   string           `json:"occurred_at"`
+	ReporterDID  string           `json:"reporter_did,omitempty" metadata:",optional"`
+	Photos       []LostFoundPhoto `json:"photos"`
+	Status       string           `json:"status"`
+	MatchID      string           `json:"match_id,omitempty" metadata:",optional"`
+	ClosedReason string           `json:"closed_reason,omitempty" metadata:",optional"`
+	ReportedBy   string           `json:"reported_by"`
+	ReportedAt   string           `json:"reported_at"`
+	UpdatedBy    string           `json:"updated_by"`
+	UpdatedAt    string           `json:"updated_at"`
+	OwnerOrg     string           `json:"owner_org,omitempty" metadata:",optional"`
+	TxID         string           `json:"tx_id"`
+}
+
+// LostFoundPhoto is a photo of an item, anchored by its hash
+type LostFoundPhoto struct {
+	PhotoHash string `json:"photo_hash"`
+	MediaType string `json:"media_type"`
+	AddedBy   string `json:"added_by"`
+	AddedAt   string `json:"added_at"`
+}
+
+// LostFoundMatchDocument resolves whether a lost and a found item are the same. A returned match
+// closes both items; a rejected one records that they are not, so they are not proposed again.
+type LostFoundMatchDocument struct {
+	DocType     string `json:"doc_type"`
+	MatchID     string `json:"match_id"`
+	LostItemID  string `json:"lost_item_id"`
+	FoundItemID string `json:"found_item_id"`
+	Resolution  string `json:"resolution"`
+	Note        string `json:"note,omitempty" metadata:",optional"`
+	ResolvedBy  string `json:"resolved_by"`
+	ResolvedAt  string `json:"resolved_at"`
+	OwnerOrg    string `json:"owner_org,omitempty" metadata:",optional"`
+	TxID        string `json:"tx_id"`
+}
+
+// AdvisoryAnchorDocument commits to a weather or disaster advisory as the gateway ingested it.
+// Digest is a SHA-256 over the advisory as published, which stays off the ledger; ZoneIDs are the
+// zones whose risk the advisory raised.
+type AdvisoryAnchorDocument struct {
+	DocType    string   `json:"doc_type"`
+	AnchorID   string   `json:"anchor_id"`
+	AdvisoryID string   `json:"advisory_id"`
+	Source     string   `json:"source"`
+	Digest     string   `json:"digest"`
+	Severity   string   `json:"severity"`
+	ZoneIDs    []string `json:"zone_ids"`
+	IssuedAt   string   `json:"issued_at"`
+	ExpiresAt  string   `json:"expires_at"`
+	AnchoredBy string   `json:"anchored_by"`
+	AnchoredAt string   `json:"anchored_at"`
+	TxID       string   `json:"tx_id"`
+}
+
+// BroadcastDocument records the decision to warn every tourist near an incident or advisory. Who
+// was warned stays off the ledger: RecipientsDigest is the SHA-256 digest of the broadcast ID and
+// the sorted digital IDs addressed, AreaDigest of the area searched and MessageDigest of the text.
+type BroadcastDocument struct {
+	DocType          string `json:"doc_type"`
+	BroadcastID      string `json:"broadcast_id"`
+	SourceType       string `json:"source_type"`
+	SourceID         string `json:"source_id"`
+	Severity         string `json:"severity"`
+	AreaDigest       string `json:"area_digest"`
+	ZoneID           string `json:"zone_id,omitempty" metadata:",optional"`
+	PingsSince       string `json:"pings_since"`
+	MessageDigest    string `json:"message_digest"`
+	RecipientsDigest string `json:"recipients_digest"`
+	Candidates       int    `json:"candidates"`
+	OptedOut         int    `json:"opted_out"`
+	Recipients       int    `json:"recipients"`
+	DecidedBy        string `json:"decided_by"`
+	DecidedAt        string `json:"decided_at"`
+	OwnerOrg         string `json:"owner_org,omitempty" metadata:",optional"`
+	TxID             string `json:"tx_id"`
+}
+
+// ReportAnchorDocument commits to a scheduled operations report. The PDF and CSV stay off the
+// ledger; PDFHash and CSVHash are their SHA-256 digests, and the period is [PeriodStart, PeriodEnd).
+type ReportAnchorDocument struct {
+	DocType     string `json:"doc_type"`
+	ReportID    string `json:"report_id"`
+	Period      string `json:"period"`
+	PeriodStart string `json:"period_start"`
+	PeriodEnd   string `json:"period_end"`
+	PDFHash     string `json:"pdf_hash"`
+	CSVHash     string `json:"csv_hash"`
+	GeneratedBy string `json:"generated_by"`
+	AnchoredAt  string `json:"anchored_at"`
+	TxID        string `json:"tx_id"`
+}
+
+// SLABreachDocument records that an incident went unacknowledged or unresolved past its severity's
+// target. Breaches are detected off-chain by the gateway's SLA monitor; BreachID is deterministic, so
+// each incident breaches each target at most once.
+type SLABreachDocument struct {
+	DocType        string `json:"doc_type"`
+	BreachID       string `json:"breach_id"`
+	IncidentID     string `json:"incident_id"`
+	Kind           string `json:"kind"`
+	Severity       string `json:"severity"`
+	District       string `json:"district"`
+	TargetSeconds  int    `json:"target_seconds"`
+	ElapsedSeconds int    `json:"elapsed_seconds"`
+	DetectedBy     string `json:"detected_by"`
+	DetectedAt     string `json:"detected_at"`
+	OwnerOrg       string `json:"owner_org,omitempty" metadata:",optional"`
+	TxID           string `json:"tx_id"`
+}
+
+// IncidentArchiveDocument anchors the digest of a resolved incident's archive bundle in cold
+// storage. The bundle holds the incident's ledger versions, evidence and audit entries with the
+// blocks that committed them, so it can be checked against any peer's copy of the chain after the
+// incident has left the off-chain index.
+type IncidentArchiveDocument struct {
+	DocType    string `json:"doc_type"`
+	ArchiveID  string `json:"archive_id"`
+	IncidentID string `json:"incident_id"`
+	Digest     string `json:"digest"`
+	ObjectKey  string `json:"object_key"`
+	Size       int    `json:"size"`
+	ProofCount int    `json:"proof_count"`
+	ArchivedBy string `json:"archived_by"`
+	ArchivedAt string `json:"archived_at"`
+	OwnerOrg   string `json:"owner_org,omitempty" metadata:",optional"`
+	TxID       string `json:"tx_id"`
+}
+
+// ErasureDocument proves that a tourist's off-chain personal data was erased, on their verified
+// request or once its retention window passed. The ledger keeps no personal data itself; Manifest
+// counts what was purged in each category and ManifestHash is the SHA-256 digest of it as submitted.
+type ErasureDocument struct {
+	DocType      string         `json:"doc_type"`
+	ErasureID    string         `json:"erasure_id"`
+	DigitalID    string         `json:"digital_id"`
+	Reason       string         `json:"reason"`
+	Verification string         `json:"verification"`
+	VerifiedBy   string         `json:"verified_by,omitempty"`
+	Manifest     []ErasureEntry `json:"manifest"`
+	ManifestHash string         `json:"manifest_hash"`
+	ErasedBy     string         `json:"erased_by"`
+	ErasedAt     string         `json:"erased_at"`
+	TxID         string         `json:"tx_id"`
+}
+
+// ErasureEntry is how many items of one category of personal data were purged
+type ErasureEntry struct {
+	Category string `json:"category"`
+	Purged   int    `json:"purged"`
+}
+
+// AssignmentDocument sends a responder unit to an SOS alert or incident. An assignment the unit does
+// not acknowledge by AckDeadline is escalated, which closes it and names the assignment replacing it;
+// one the unit declines is closed the same way, with its reason.
+type AssignmentDocument struct {
+	DocType        string  `json:"doc_type"`
+	AssignmentID   string  `json:"assignment_id"`
+	SubjectType    string  `json:"subject_type"`
+	SubjectID      string  `json:"subject_id"`
+	UnitID         string  `json:"unit_id"`
+	UnitKind       string  `json:"unit_kind"`
+	Attempt        int     `json:"attempt"`
+	DistanceKm     float64 `json:"distance_km"`
+	Status         string  `json:"status"`
+	AssignedAt     string  `json:"assigned_at"`
+	AckDeadline    string  `json:"ack_deadline"`
+	AcknowledgedAt string  `json:"acknowledged_at,omitempty" metadata:",optional"`
+	AcknowledgedBy string  `json:"acknowledged_by,omitempty" metadata:",optional"`
+	EscalatedAt    string  `json:"escalated_at,omitempty" metadata:",optional"`
+	EscalatedTo    string  `json:"escalated_to,omitempty" metadata:",optional"`
+	DeclinedAt     string  `json:"declined_at,omitempty" metadata:",optional"`
+	DeclineReason  string  `json:"decline_reason,omitempty" metadata:",optional"`
+	OwnerOrg       string  `json:"owner_org,omitempty" metadata:",optional"`
+	TxID           string  `json:"tx_id"`
+}
+
+// ZoneDocument is a geofence zone in the zone registry. Geometry is a GeoJSON Polygon or MultiPolygon;
+// when ActiveFrom and ActiveTo are set the zone only applies between those times of day ("15:04") in
+// Timezone, wrapping past midnight when ActiveTo is earlier.
+type ZoneDocument struct {
+	DocType    string `json:"doc_type"`
+	ZoneID     string `json:"zone_id"`
+	Name       string `json:"name"`
+	Geometry   string `json:"geometry"`
+	RiskLevel  string `json:"risk_level"`
+	ActiveFrom string `json:"active_from,omitempty" metadata:",optional"`
+	ActiveTo   string `json:"active_to,omitempty" metadata:",optional"`
+	Timezone   string `json:"timezone,omitempty" metadata:",optional"`
+	UpdatedBy  string `json:"updated_by"`
+	UpdatedAt  string `json:"updated_at"`
+	// RiskScore (0 to 1) and ScoredRiskLevel are the gateway's assessment of the zone from the
+	// incidents and SOS alerts in it, as of ScoredAt. They survive edits to the zone.
+	RiskScore       float64 `json:"risk_score,omitempty" metadata:",optional"`
+	ScoredRiskLevel string  `json:"scored_risk_level,omitempty" metadata:",optional"`
+	ScoredIncidents int     `json:"scored_incidents,omitempty" metadata:",optional"`
+	ScoredAt        string  `json:"scored_at,omitempty" metadata:",optional"`
+	TxID            string  `json:"tx_id"`
+}
+
+// ZoneScore is one zone's entry in a ScoreZones batch
+type ZoneScore struct {
+	ZoneID          string  `json:"zone_id"`
+	RiskScore       float64 `json:"risk_score"`
+	ScoredRiskLevel string  `json:"scored_risk_level"`
+	ScoredIncidents int     `json:"scored_incidents"`
+	ScoredAt        string  `json:"scored_at"`
+}
+
+// ConsentDocument is a tourist's consent to one purpose, keyed CONSENT:<digital id>:<purpose>. RecordHash
+// is the gateway's hash of the change record, which includes PreviousHash, so a consent's versions
+// form a hash chain.
+type ConsentDocument struct {
+	DocType       string `json:"doc_type"`
+	ConsentID     string `json:"consent_id"`
+	DigitalID     string `json:"digital_id"`
+	Purpose       string `json:"purpose"`
+	Action        string `json:"action"`
+	Status        string `json:"status"`
+	PolicyVersion string `json:"policy_version"`
+	GrantedAt     string `json:"granted_at"`
+	ExpiresAt     string `json:"expires_at"`
+	WithdrawnAt   string `json:"withdrawn_at,omitempty" metadata:",optional"`
+	Version       int    `json:"version"`
+	PreviousHash  string `json:"previous_hash,omitempty" metadata:",optional"`
+	RecordHash    string `json:"record_hash"`
+	UpdatedBy     string `json:"updated_by"`
+	UpdatedAt     string `json:"updated_at"`
+	OwnerOrg      string `json:"owner_org,omitempty" metadata:",optional"`
+	TxID          string `json:"tx_id"`
+}
+
+// ConsentReceiptDocument anchors the receipt a tourist was given for one version of a consent.
+// ReceiptHash is the SHA-256 of the receipt as issued; ConsentRecordHash is the record hash of the
+// consent version it describes.
+type ConsentReceiptDocument struct {
+	DocType           string `json:"doc_type"`
+	ReceiptID         string `json:"receipt_id"`
+	ConsentID         string `json:"consent_id"`
+	DigitalID         string `json:"digital_id"`
+	Purpose           string `json:"purpose"`
+	Action            string `json:"action"`
+	ConsentVersion    int    `json:"consent_version"`
+	ConsentRecordHash string `json:"consent_record_hash"`
+	ReceiptHash       string `json:"receipt_hash"`
+	IssuedBy          string `json:"issued_by"`
+	IssuedAt          string `json:"issued_at"`
+	OwnerOrg          string `json:"owner_org,omitempty" metadata:",optional"`
+	TxID              string `json:"tx_id"`
+}
+
+// ConsentVersion is one committed version of a consent
+type ConsentVersion struct {
+	TxID      string           `json:"tx_id"`
+	Timestamp string           `json:"timestamp"`
+	Deleted   bool             `json:"deleted"`
+	Consent   *ConsentDocument `json:"consent,omitempty" metadata:",optional"`
+}
+
+// IncidentVersion is one committed version of an incident, as returned by GetIncidentHistory
+type IncidentVersion struct {
+	TxID      string            `json:"tx_id"`
+	Timestamp string            `json:"timestamp"`
+	Deleted   bool              `json:"deleted"`
+	Incident  *IncidentDocument `json:"incident,omitempty" metadata:",optional"`
+}
+
 // AuditDocument represents an audit log entry
 type AuditDocument struct {
 	DocType   string `json:"doc_type"`
@@ -54,11 +738,244 @@ type AuditDocument struct {
 	TargetID  string `json:"target_id"`
 	Timestamp string `json:"timestamp"`
 	TxID      string `json:"tx_id"`
+	// Submitter is the MSP ID and certificate common name that signed the transaction
+	Submitter string `json:"submitter,omitempty"`
+	// Route, RequestID and Status describe the API request behind an API_* entry
+	Route     string `json:"route,omitempty" metadata:",optional"`
+	RequestID string `json:"request_id,omitempty" metadata:",optional"`
+	Status    string `json:"status,omitempty" metadata:",optional"`
+}
+
+// AuditQueryResult is one page of audit logs matching a search
+type AuditQueryResult struct {
+	Audits       []*AuditDocument `json:"audits"`
+	Bookmark     string           `json:"bookmark"`
+	FetchedCount int32            `json:"fetched_count"`
+}
+
+// DashboardStats summarises the world state for the operations dashboard
+type DashboardStats struct {
+	OpenIncidentsBySeverity map[string]int `json:"open_incidents_by_severity"`
+	SOSAlerts               int            `json:"sos_alerts"`
+	DIDsIssued              int            `json:"dids_issued"`
+	DIDsIssuedRecently      int            `json:"dids_issued_recently"`
+	DIDsExpiring            int            `json:"dids_expiring"`
+	AcknowledgedIncidents   int            `json:"acknowledged_incidents"`
+	AcknowledgeSeconds      int64          `json:"acknowledge_seconds"`
+	EvidenceCount           int            `json:"evidence_count"`
+	EvidenceRecently        int            `json:"evidence_recently"`
+}
+
+// Incident lifecycle states and severities. Incidents only move forward through the states.
+const (
+	incidentStatusOpen         = "open"
+	incidentStatusAcknowledged = "acknowledged"
+	incidentStatusResolved     = "resolved"
+)
+
+var incidentStatusOrder = map[string]int{"open": 0, "acknowledged": 1, "resolved": 2, "closed": 3}
+
+var incidentSeverities = map[string]bool{"low": true, "medium": true, "high": true, "critical": true}
+
+var incidentCategories = map[string]bool{
+	"missing_person": true, "medical": true, "accident": true, "theft": true, "harassment": true, "anomaly": true, "geofence": true, "other": true,
+}
+
+// Assignment states. An assignment leaves assigned exactly once, by acknowledgement, decline or
+// escalation.
+const (
+	assignmentStatusAssigned     = "assigned"
+	assignmentStatusAcknowledged = "acknowledged"
+	assignmentStatusDeclined     = "declined"
+	assignmentStatusEscalated    = "escalated"
+)
+
+var assignmentSubjects = map[string]string{"sos": "sos", "incident": "incident"}
+
+var unitKinds = map[string]bool{"police": true, "medical": true}
+
+var reportPeriods = map[string]bool{"daily": true, "weekly": true}
+
+// slaBreachKinds are the targets an incident can breach: being acknowledged and being resolved
+var slaBreachKinds = map[string]bool{"acknowledge": true, "resolve": true}
+
+// erasureVerifications are how an erasure was authorized for each reason
+var erasureVerifications = map[string][]string{
+	"request":   {"document", "officer"},
+	"retention": {"retention_window"},
+}
+
+var consentPurposes = map[string]bool{"location_tracking": true, "family_sharing": true, "data_retention": true}
+
+// Consent states. A withdrawn or expired consent can be granted again.
+const (
+	consentStatusGranted   = "granted"
+	consentStatusWithdrawn = "withdrawn"
+)
+
+var zoneRiskLevels = map[string]bool{"low": true, "medium": true, "high": true, "restricted": true}
+
+// maxLedgerGeohashPrecision caps recorded locations at a cell of about 1.2 km by 0.6 km, enough for
+// heatmaps without putting exact positions on the ledger
+const maxLedgerGeohashPrecision = 6
+
+const maxQueryPageSize = 100
+
+// Documents are stored under composite keys namespaced by their doc_type, so an ID only has to be
+// unique within its type. Contracts up to v2 stored them under their raw IDs; until
+// MigrateLegacyDocuments has moved a document, reads fall back to its raw ID and the next write
+// moves it.
+func documentKey(ctx contractapi.TransactionContextInterface, docType, id string) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(docType, []string{id})
+}
+
+// legacyFIRAllocationPrefix is how v2 contracts kept FIR allocations apart from the FIRs themselves
+const legacyFIRAllocationPrefix = "FIRALLOC:"
+
+// legacyKey is the raw key a v2 contract stored a document under
+func legacyKey(docType, id string) string {
+	if docType == "fir_allocation" {
+		return legacyFIRAllocationPrefix + id
+	}
+	return id
+}
+
+// legacyDocumentID is the ID of the document a v2 contract stored under a raw key
+func legacyDocumentID(docType, key string) string {
+	if docType == "fir_allocation" {
+		return strings.TrimPrefix(key, legacyFIRAllocationPrefix)
+	}
+	return key
+}
+
+// documentType reads the doc_type of a stored value, or "" when it is not a document
+func documentType(value []byte) string {
+	var doc struct {
+		DocType string `json:"doc_type"`
+	}
+	if json.Unmarshal(value, &doc) != nil {
+		return ""
+	}
+	return doc.DocType
+}
+
+// getLegacyState returns the document a v2 contract stored under its raw ID, or nil when there is
+// none of that type
+func getLegacyState(ctx contractapi.TransactionContextInterface, docType, id string) ([]byte, error) {
+	value, err := ctx.GetStub().GetState(legacyKey(docType, id))
+	if err != nil || value == nil || documentType(value) != docType {
+		return nil, err
+	}
+	return value, nil
+}
+
+// getState returns a document, or nil when there is none
+func getState(ctx contractapi.TransactionContextInterface, docType, id string) ([]byte, error) {
+	key, err := documentKey(ctx, docType, id)
+	if err != nil {
+		return nil, err
+	}
+	value, err := ctx.GetStub().GetState(key)
+	if err != nil || value != nil {
+		return value, err
+	}
+	return getLegacyState(ctx, docType, id)
+}
+
+// putState writes a document under its namespaced key, removing any copy under its raw ID
+func putState(ctx contractapi.TransactionContextInterface, docType, id string, value []byte) error {
+	key, err := documentKey(ctx, docType, id)
+	if err != nil {
+		return err
+	}
+	if err := ctx.GetStub().PutState(key, value); err != nil {
+		return err
+	}
+	return delLegacyState(ctx, docType, id)
+}
+
+func delState(ctx contractapi.TransactionContextInterface, docType, id string) error {
+	key, err := documentKey(ctx, docType, id)
+	if err != nil {
+		return err
+	}
+	if err := ctx.GetStub().DelState(key); err != nil {
+		return err
+	}
+	return delLegacyState(ctx, docType, id)
+}
+
+func delLegacyState(ctx contractapi.TransactionContextInterface, docType, id string) error {
+	legacy, err := getLegacyState(ctx, docType, id)
+	if err != nil || legacy == nil {
+		return err
+	}
+	return ctx.GetStub().DelState(legacyKey(docType, id))
+}
+
+// documentModification is one committed change to a document
+type documentModification struct {
+	txID      string
+	timestamp string
+	deleted   bool
+	value     []byte
+}
+
+// documentHistory returns every committed change to a document, oldest first: those under its raw
+// ID, then those under its namespaced key. The deletion of the raw ID by the transaction that
+// moved the document is left out, as the document lived on.
+func documentHistory(ctx contractapi.TransactionContextInterface, docType, id string) ([]documentModification, error) {
+	legacy, err := keyHistory(ctx, legacyKey(docType, id))
+	if err != nil {
+		return nil, err
+	}
+	for _, modification := range legacy {
+		if !modification.deleted && documentType(modification.value) != docType {
+			legacy = nil
+			break
+		}
+	}
+	key, err := documentKey(ctx, docType, id)
+	if err != nil {
+		return nil, err
+	}
+	namespaced, err := keyHistory(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if n := len(legacy); n > 0 && len(namespaced) > 0 && legacy[n-1].deleted && legacy[n-1].txID == namespaced[0].txID {
+		legacy = legacy[:n-1]
+	}
+	return append(legacy, namespaced...), nil
+}
+
+func keyHistory(ctx contractapi.TransactionContextInterface, key string) ([]documentModification, error) {
+	iterator, err := ctx.GetStub().GetHistoryForKey(key)
+	if err != nil {
+		return nil, err
+	}
+	defer iterator.Close()
+
+	var modifications []documentModification
+	for iterator.HasNext() {
+		modification, err := iterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		change := documentModification{txID: modification.TxId, deleted: modification.IsDelete, value: modification.Value}
+		if ts := modification.Timestamp; ts != nil {
+			change.timestamp = ts.AsTime().UTC().Format(time.RFC3339)
+		}
+		modifications = append(modifications, change)
+	}
+	// The peer returns the newest version first
+	slices.Reverse(modifications)
+	return modifications, nil
 }
 
 // Helper function to read state from ledger
-func (s *SIHChaincode) readState(ctx contractapi.TransactionContextInterface, id string) ([]byte, error) {
-	dataJSON, err := ctx.GetStub().GetState(id)
+func (s *SIHChaincode) readState(ctx contractapi.TransactionContextInterface, docType, id string) ([]byte, error) {
+	dataJSON, err := getState(ctx, docType, id)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read from world state: %w", err)
 	}
@@ -84,6 +1001,7 @@ func (s *SIHChaincode) createAuditLog(ctx contractapi.TransactionContextInterfac
 		TargetID:  targetID,
 		Timestamp: timestamp,
 		TxID:      txID,
+		Submitter: submitterID(ctx),
 	}
 
 	auditJSON, err := json.Marshal(audit)
@@ -91,14 +1009,65 @@ func (s *SIHChaincode) createAuditLog(ctx contractapi.TransactionContextInterfac
 		return err
 	}
 
-	return ctx.GetStub().PutState(auditID, auditJSON)
+	return putState(ctx, "audit", auditID, auditJSON)
+}
+
+// submitterID identifies the client that signed the transaction as "mspid/common name", which the
+// caller cannot forge the way it can the actor argument
+func submitterID(ctx contractapi.TransactionContextInterface) string {
+	id := ctx.GetClientIdentity()
+	if id == nil {
+		return ""
+	}
+	mspID, err := id.GetMSPID()
+	if err != nil {
+		return ""
+	}
+	cert, err := id.GetX509Certificate()
+	if err != nil || cert == nil {
+		return mspID
+	}
+	return mspID + "/" + cert.Subject.CommonName
+}
+
+// txTime returns the transaction's timestamp, set by the client and the same on every endorsing peer.
+// The peer's own clock differs between endorsements, so their write sets would not match.
+func txTime(ctx contractapi.TransactionContextInterface) (time.Time, error) {
+	timestamp, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read the transaction timestamp: %w", err)
+	}
+	return timestamp.AsTime().UTC(), nil
+}
+
+// txTimestamp returns the transaction's timestamp as documents store it
+func txTimestamp(ctx contractapi.TransactionContextInterface) (string, error) {
+	now, err := txTime(ctx)
+	if err != nil {
+		return "", err
+	}
+	return now.Format(time.RFC3339), nil
+}
+
+// submitterOrg returns the MSP ID of the organization that signed the transaction, which owns the
+// incidents, evidence and SOS alerts it creates
+func submitterOrg(ctx contractapi.TransactionContextInterface) string {
+	id := ctx.GetClientIdentity()
+	if id == nil {
+		return ""
+	}
+	mspID, err := id.GetMSPID()
+	if err != nil {
+		return ""
+	}
+	return mspID
 }
 
 // ========== DID DOCUMENT CRUD OPERATIONS ==========
 
 // CreateDID creates a new Digital ID document
 func (s *SIHChaincode) CreateDID(ctx contractapi.TransactionContextInterface, digitalID, consentHash, expiresAt, issuer string) error {
-	existing, err := s.readState(ctx, digitalID)
+	existing, err := s.readState(ctx, "did", digitalID)
 	if err == nil && existing != nil {
 		return fmt.Errorf("the DID document %s already exists", digitalID)
 	}
@@ -121,7 +1090,7 @@ func (s *SIHChaincode) CreateDID(ctx contractapi.TransactionContextInterface, di
 		return err
 	}
 
-	err = ctx.GetStub().PutState(digitalID, didJSON)
+	err = putState(ctx, "did", digitalID, didJSON)
 	if err != nil {
 		return err
 	}
@@ -131,9 +1100,91 @@ func (s *SIHChaincode) CreateDID(ctx contractapi.TransactionContextInterface, di
 	return nil
 }
 
+// DIDIssuance is one DID in a BatchIssueDID manifest
+type DIDIssuance struct {
+	DigitalID   string `json:"digital_id"`
+	ConsentHash string `json:"consent_hash"`
+	ExpiresAt   string `json:"expires_at"`
+	Issuer      string `json:"issuer"`
+}
+
+// BatchIssueResult lists the DIDs a batch issued and those it skipped because they already existed
+type BatchIssueResult struct {
+	Issued   []string `json:"issued"`
+	Existing []string `json:"existing"`
+}
+
+const maxDIDsPerTx = 200
+
+// BatchIssueDID issues DIDs for a group, such as a tour operator's travellers, in one transaction.
+// DIDs that already exist are skipped rather than failing the batch, so a retried batch is safe.
+// One BatchIssueDID event carries every issued document.
+func (s *SIHChaincode) BatchIssueDID(ctx contractapi.TransactionContextInterface, didsJSON string) (*BatchIssueResult, error) {
+	var issuances []DIDIssuance
+	if err := json.Unmarshal([]byte(didsJSON), &issuances); err != nil {
+		return nil, fmt.Errorf("invalid DIDs: %v", err)
+	}
+	if len(issuances) == 0 || len(issuances) > maxDIDsPerTx {
+		return nil, fmt.Errorf("dids must contain between 1 and %d entries", maxDIDsPerTx)
+	}
+
+	timestamp, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	txID := ctx.GetStub().GetTxID()
+	result := &BatchIssueResult{Issued: []string{}, Existing: []string{}}
+	issued := []DIDDocument{}
+	seen := map[string]bool{}
+	for _, issuance := range issuances {
+		if issuance.DigitalID == "" || issuance.ConsentHash == "" || issuance.ExpiresAt == "" || issuance.Issuer == "" {
+			return nil, fmt.Errorf("DID %q must have a consent hash, expiry and issuer", issuance.DigitalID)
+		}
+		// Writes are not visible to reads in the same transaction, so duplicates are caught here
+		if seen[issuance.DigitalID] {
+			return nil, fmt.Errorf("the DID %s appears more than once in the batch", issuance.DigitalID)
+		}
+		seen[issuance.DigitalID] = true
+
+		existing, err := s.readState(ctx, "did", issuance.DigitalID)
+		if err == nil && existing != nil {
+			result.Existing = append(result.Existing, issuance.DigitalID)
+			continue
+		}
+		did := DIDDocument{
+			DocType:     "did",
+			DigitalID:   issuance.DigitalID,
+			ConsentHash: issuance.ConsentHash,
+			IssuedAt:    timestamp,
+			ExpiresAt:   issuance.ExpiresAt,
+			Issuer:      issuance.Issuer,
+			TxID:        txID,
+		}
+		didJSON, err := json.Marshal(did)
+		if err != nil {
+			return nil, err
+		}
+		if err := putState(ctx, "did", did.DigitalID, didJSON); err != nil {
+			return nil, err
+		}
+		s.createAuditLog(ctx, did.Issuer, "CREATE_DID", did.DigitalID)
+		result.Issued = append(result.Issued, did.DigitalID)
+		issued = append(issued, did)
+	}
+
+	if len(issued) > 0 {
+		eventJSON, err := json.Marshal(issued)
+		if err != nil {
+			return nil, err
+		}
+		ctx.GetStub().SetEvent("BatchIssueDID", eventJSON)
+	}
+	return result, nil
+}
+
 // ReadDID returns the DID document with given digital ID
 func (s *SIHChaincode) ReadDID(ctx contractapi.TransactionContextInterface, digitalID string) (*DIDDocument, error) {
-	didJSON, err := s.readState(ctx, digitalID)
+	didJSON, err := s.readState(ctx, "did", digitalID)
 	if err != nil {
 		return nil, err
 	}
@@ -171,7 +1222,7 @@ func (s *SIHChaincode) UpdateDID(ctx contractapi.TransactionContextInterface, di
 		return err
 	}
 
-	err = ctx.GetStub().PutState(digitalID, didJSON)
+	err = putState(ctx, "did", digitalID, didJSON)
 	if err != nil {
 		return err
 	}
@@ -183,12 +1234,12 @@ func (s *SIHChaincode) UpdateDID(ctx contractapi.TransactionContextInterface, di
 
 // DeleteDID deletes a DID document
 func (s *SIHChaincode) DeleteDID(ctx contractapi.TransactionContextInterface, digitalID, actor string) error {
-	didJSON, err := s.readState(ctx, digitalID)
+	didJSON, err := s.readState(ctx, "did", digitalID)
 	if err != nil {
 		return err
 	}
 
-	err = ctx.GetStub().DelState(digitalID)
+	err = delState(ctx, "did", digitalID)
 	if err != nil {
 		return err
 	}
@@ -198,14 +1249,308 @@ func (s *SIHChaincode) DeleteDID(ctx contractapi.TransactionContextInterface, di
 	return nil
 }
 
-// ========== INCIDENT DOCUMENT CRUD OPERATIONS ==========
+// ========== CONSENT OPERATIONS ==========
 
-// CreateIncident creates a new incident record
-func (s *SIHChaincode) CreateIncident(ctx contractapi.TransactionContextInterface, incidentID, incidentSummaryHash, reporter string) error {
-	existing, err := s.readState(ctx, incidentID)
-	if err == nil && existing != nil {
-		return fmt.Errorf("the incident %s already exists", incidentID)
-	}
+func consentKey(digitalID, purpose string) string {
+	return "CONSENT:" + digitalID + ":" + purpose
+}
+
+// GrantConsent records a tourist's consent to a purpose until expiresAt. previousHash must be the
+// record hash of the consent's current version, or empty for a first grant, so concurrent changes
+// cannot fork the chain.
+func (s *SIHChaincode) GrantConsent(ctx contractapi.TransactionContextInterface, digitalID, purpose, policyVersion, expiresAt, changedAt, previousHash, recordHash, actor string) error {
+	if !consentPurposes[purpose] {
+		return fmt.Errorf("invalid consent purpose %q", purpose)
+	}
+	if _, err := s.ReadDID(ctx, digitalID); err != nil {
+		return err
+	}
+	if policyVersion == "" {
+		return fmt.Errorf("policy version cannot be empty")
+	}
+	consent, err := s.readConsentForChange(ctx, consentKey(digitalID, purpose), changedAt, previousHash, recordHash)
+	if err != nil {
+		return err
+	}
+	if consent.Status == consentStatusGranted && consent.ExpiresAt > changedAt {
+		return fmt.Errorf("the consent %s is already granted and must be renewed instead", consent.ConsentID)
+	}
+	if expiresAt <= changedAt {
+		return fmt.Errorf("expiry must be after %s", changedAt)
+	}
+	consent.DigitalID, consent.Purpose, consent.PolicyVersion = digitalID, purpose, policyVersion
+	consent.Status, consent.GrantedAt, consent.ExpiresAt, consent.WithdrawnAt = consentStatusGranted, changedAt, expiresAt, ""
+	return s.putConsent(ctx, consent, "grant", changedAt, recordHash, actor, "GrantConsent", "GRANT_CONSENT")
+}
+
+// RenewConsent extends a granted consent to a later expiresAt
+func (s *SIHChaincode) RenewConsent(ctx contractapi.TransactionContextInterface, digitalID, purpose, expiresAt, changedAt, previousHash, recordHash, actor string) error {
+	consent, err := s.readConsentForChange(ctx, consentKey(digitalID, purpose), changedAt, previousHash, recordHash)
+	if err != nil {
+		return err
+	}
+	if consent.Status != consentStatusGranted {
+		return fmt.Errorf("the consent %s must be granted first", consent.ConsentID)
+	}
+	if expiresAt <= consent.ExpiresAt || expiresAt <= changedAt {
+		return fmt.Errorf("expiry must be after %s", max(consent.ExpiresAt, changedAt))
+	}
+	consent.ExpiresAt = expiresAt
+	return s.putConsent(ctx, consent, "renew", changedAt, recordHash, actor, "RenewConsent", "RENEW_CONSENT")
+}
+
+// WithdrawConsent withdraws a granted consent
+func (s *SIHChaincode) WithdrawConsent(ctx contractapi.TransactionContextInterface, digitalID, purpose, changedAt, previousHash, recordHash, actor string) error {
+	consent, err := s.readConsentForChange(ctx, consentKey(digitalID, purpose), changedAt, previousHash, recordHash)
+	if err != nil {
+		return err
+	}
+	if consent.Status != consentStatusGranted {
+		return fmt.Errorf("the consent %s must be granted first", consent.ConsentID)
+	}
+	consent.Status, consent.WithdrawnAt = consentStatusWithdrawn, changedAt
+	return s.putConsent(ctx, consent, "withdraw", changedAt, recordHash, actor, "WithdrawConsent", "WITHDRAW_CONSENT")
+}
+
+// readConsentForChange returns the consent to change, or a new one, after checking the change
+// record's hash and that it follows the current version
+func (s *SIHChaincode) readConsentForChange(ctx contractapi.TransactionContextInterface, consentID, changedAt, previousHash, recordHash string) (*ConsentDocument, error) {
+	if _, err := time.Parse(time.RFC3339, changedAt); err != nil {
+		return nil, fmt.Errorf("invalid change time %q", changedAt)
+	}
+	if recordHash == "" {
+		return nil, fmt.Errorf("record hash cannot be empty")
+	}
+	consent := &ConsentDocument{DocType: "consent", ConsentID: consentID}
+	existing, err := getState(ctx, "consent", consentID)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		if err := json.Unmarshal(existing, consent); err != nil {
+			return nil, err
+		}
+	}
+	if previousHash != consent.RecordHash {
+		return nil, fmt.Errorf("invalid previous hash: the consent %s has changed since version %d", consentID, consent.Version)
+	}
+	return consent, nil
+}
+
+func (s *SIHChaincode) putConsent(ctx contractapi.TransactionContextInterface, consent *ConsentDocument, action, changedAt, recordHash, actor, eventName, auditAction string) error {
+	consent.Action = action
+	consent.Version++
+	consent.PreviousHash, consent.RecordHash = consent.RecordHash, recordHash
+	consent.UpdatedBy, consent.UpdatedAt = actor, changedAt
+	consent.OwnerOrg = submitterOrg(ctx)
+	consent.TxID = ctx.GetStub().GetTxID()
+	consentJSON, err := json.Marshal(consent)
+	if err != nil {
+		return err
+	}
+	if err := putState(ctx, "consent", consent.ConsentID, consentJSON); err != nil {
+		return err
+	}
+	ctx.GetStub().SetEvent(eventName, consentJSON)
+	s.createAuditLog(ctx, actor, auditAction, consent.ConsentID)
+	return nil
+}
+
+// ReadConsent returns the consent with the given ID
+func (s *SIHChaincode) ReadConsent(ctx contractapi.TransactionContextInterface, consentID string) (*ConsentDocument, error) {
+	consentJSON, err := s.readState(ctx, "consent", consentID)
+	if err != nil {
+		return nil, err
+	}
+
+	var consent ConsentDocument
+	if err := json.Unmarshal(consentJSON, &consent); err != nil {
+		return nil, err
+	}
+	if consent.DocType != "consent" {
+		return nil, fmt.Errorf("%s is not a consent", consentID)
+	}
+	return &consent, nil
+}
+
+// GetConsentsByDID returns a tourist's consents, one per purpose
+func (s *SIHChaincode) GetConsentsByDID(ctx contractapi.TransactionContextInterface, digitalID string) ([]*ConsentDocument, error) {
+	consents := []*ConsentDocument{}
+	err := forEachQueryResult(ctx, map[string]interface{}{"doc_type": "consent", "digital_id": digitalID}, func(value []byte) error {
+		var consent ConsentDocument
+		if err := json.Unmarshal(value, &consent); err != nil {
+			return err
+		}
+		consents = append(consents, &consent)
+		return nil
+	})
+	return consents, err
+}
+
+// GetConsentHistory returns every committed version of a consent, oldest first
+func (s *SIHChaincode) GetConsentHistory(ctx contractapi.TransactionContextInterface, consentID string) ([]*ConsentVersion, error) {
+	modifications, err := documentHistory(ctx, "consent", consentID)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := []*ConsentVersion{}
+	for _, modification := range modifications {
+		version := &ConsentVersion{TxID: modification.txID, Timestamp: modification.timestamp, Deleted: modification.deleted}
+		if !modification.deleted {
+			version.Consent = &ConsentDocument{}
+			if err := json.Unmarshal(modification.value, version.Consent); err != nil {
+				return nil, err
+			}
+		}
+		versions = append(versions, version)
+	}
+	return versions, nil
+}
+
+// RecordConsentReceipt anchors the hash of the receipt issued for a consent version. The version
+// must be the consent's current one, with the same record hash, so a receipt can only describe
+// what the ledger holds. Recording the same receipt again is a no-op.
+func (s *SIHChaincode) RecordConsentReceipt(ctx contractapi.TransactionContextInterface, consentID string, consentVersion int, consentRecordHash, receiptHash, actor string) error {
+	if len(receiptHash) != 64 {
+		return fmt.Errorf("receipt hash must be a SHA-256 digest")
+	}
+	if actor == "" {
+		return fmt.Errorf("actor cannot be empty")
+	}
+	consent, err := s.ReadConsent(ctx, consentID)
+	if err != nil {
+		return err
+	}
+	if consent.Version != consentVersion || consent.RecordHash != consentRecordHash {
+		return fmt.Errorf("the receipt describes version %d of consent %s, but version %d is current", consentVersion, consentID, consent.Version)
+	}
+
+	receiptID := fmt.Sprintf("CR:%s:v%d", consentID, consentVersion)
+	existingJSON, err := getState(ctx, "consent_receipt", receiptID)
+	if err != nil {
+		return err
+	}
+	if existingJSON != nil {
+		var existing ConsentReceiptDocument
+		if err := json.Unmarshal(existingJSON, &existing); err != nil {
+			return err
+		}
+		if existing.ReceiptHash == receiptHash {
+			return nil
+		}
+		return fmt.Errorf("a different receipt %s was already issued", receiptID)
+	}
+
+	issuedAt, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	receipt := ConsentReceiptDocument{
+		DocType:           "consent_receipt",
+		ReceiptID:         receiptID,
+		ConsentID:         consentID,
+		DigitalID:         consent.DigitalID,
+		Purpose:           consent.Purpose,
+		Action:            consent.Action,
+		ConsentVersion:    consentVersion,
+		ConsentRecordHash: consentRecordHash,
+		ReceiptHash:       receiptHash,
+		IssuedBy:          actor,
+		IssuedAt:          issuedAt,
+		OwnerOrg:          consent.OwnerOrg,
+		TxID:              ctx.GetStub().GetTxID(),
+	}
+	receiptJSON, err := json.Marshal(receipt)
+	if err != nil {
+		return err
+	}
+	if err := putState(ctx, "consent_receipt", receiptID, receiptJSON); err != nil {
+		return err
+	}
+	ctx.GetStub().SetEvent("RecordConsentReceipt", receiptJSON)
+	s.createAuditLog(ctx, actor, "RECORD_CONSENT_RECEIPT", consentID)
+	return nil
+}
+
+// ReadConsentReceipt returns the anchor of a consent receipt
+func (s *SIHChaincode) ReadConsentReceipt(ctx contractapi.TransactionContextInterface, receiptID string) (*ConsentReceiptDocument, error) {
+	receiptJSON, err := s.readState(ctx, "consent_receipt", receiptID)
+	if err != nil {
+		return nil, err
+	}
+
+	var receipt ConsentReceiptDocument
+	if err := json.Unmarshal(receiptJSON, &receipt); err != nil {
+		return nil, err
+	}
+	if receipt.DocType != "consent_receipt" {
+		return nil, fmt.Errorf("%s is not a consent receipt", receiptID)
+	}
+	return &receipt, nil
+}
+
+// GetConsentReceiptsByDID returns the receipts issued to a tourist, for every purpose
+func (s *SIHChaincode) GetConsentReceiptsByDID(ctx contractapi.TransactionContextInterface, digitalID string) ([]*ConsentReceiptDocument, error) {
+	receipts := []*ConsentReceiptDocument{}
+	err := forEachQueryResult(ctx, map[string]interface{}{"doc_type": "consent_receipt", "digital_id": digitalID}, func(value []byte) error {
+		var receipt ConsentReceiptDocument
+		if err := json.Unmarshal(value, &receipt); err != nil {
+			return err
+		}
+		receipts = append(receipts, &receipt)
+		return nil
+	})
+	return receipts, err
+}
+
+// ========== INCIDENT DOCUMENT CRUD OPERATIONS ==========
+
+// CreateIncident creates a new incident record
+func (s *SIHChaincode) CreateIncident(ctx contractapi.TransactionContextInterface, incidentID, incidentSummaryHash, reporter string) error {
+	return s.createIncident(ctx, incidentID, incidentSummaryHash, reporter, "", "", "", "")
+}
+
+// CreateIncidentWithSeverity creates a new incident record classified as low, medium, high or critical
+func (s *SIHChaincode) CreateIncidentWithSeverity(ctx contractapi.TransactionContextInterface, incidentID, incidentSummaryHash, reporter, severity string) error {
+	if !incidentSeverities[severity] {
+		return fmt.Errorf("invalid severity %q", severity)
+	}
+	return s.createIncident(ctx, incidentID, incidentSummaryHash, reporter, severity, "", "", "")
+}
+
+// CreateIncidentWithDetails creates a new incident record with a category and, optionally, the
+// digital ID of the tourist it concerns. Severity may be empty.
+func (s *SIHChaincode) CreateIncidentWithDetails(ctx contractapi.TransactionContextInterface, incidentID, incidentSummaryHash, reporter, severity, category, subjectID string) error {
+	if severity != "" && !incidentSeverities[severity] {
+		return fmt.Errorf("invalid severity %q", severity)
+	}
+	if !incidentCategories[category] {
+		return fmt.Errorf("invalid category %q", category)
+	}
+	return s.createIncident(ctx, incidentID, incidentSummaryHash, reporter, severity, category, subjectID, "")
+}
+
+// CreateIncidentWithLocation creates a new incident record with the geohash of where it happened.
+// Severity, category and subject ID may be empty.
+func (s *SIHChaincode) CreateIncidentWithLocation(ctx contractapi.TransactionContextInterface, incidentID, incidentSummaryHash, reporter, severity, category, subjectID, geohash string) error {
+	if severity != "" && !incidentSeverities[severity] {
+		return fmt.Errorf("invalid severity %q", severity)
+	}
+	if category != "" && !incidentCategories[category] {
+		return fmt.Errorf("invalid category %q", category)
+	}
+	if err := validateGeohash(geohash); err != nil {
+		return err
+	}
+	return s.createIncident(ctx, incidentID, incidentSummaryHash, reporter, severity, category, subjectID, geohash)
+}
+
+func (s *SIHChaincode) createIncident(ctx contractapi.TransactionContextInterface, incidentID, incidentSummaryHash, reporter, severity, category, subjectID, geohash string) error {
+	existing, err := s.readState(ctx, "incident", incidentID)
+	if err == nil && existing != nil {
+		return fmt.Errorf("the incident %s already exists", incidentID)
+	}
 
 	timestamp := time.Now().UTC().Format(time.RFC3339)
 	txID := ctx.GetStub().GetTxID()
@@ -216,6 +1561,12 @@ func (s *SIHChaincode) CreateIncident(ctx contractapi.TransactionContextInterfac
 		IncidentSummaryHash: incidentSummaryHash,
 		CreatedAt:           timestamp,
 		Reporter:            reporter,
+		Status:              incidentStatusOpen,
+		Severity:            severity,
+		Category:            category,
+		SubjectID:           subjectID,
+		Geohash:             geohash,
+		OwnerOrg:            submitterOrg(ctx),
 		TxID:                txID,
 	}
 
@@ -224,7 +1575,7 @@ func (s *SIHChaincode) CreateIncident(ctx contractapi.TransactionContextInterfac
 		return err
 	}
 
-	err = ctx.GetStub().PutState(incidentID, incidentJSON)
+	err = putState(ctx, "incident", incidentID, incidentJSON)
 	if err != nil {
 		return err
 	}
@@ -236,7 +1587,7 @@ func (s *SIHChaincode) CreateIncident(ctx contractapi.TransactionContextInterfac
 
 // ReadIncident returns the incident document with given incident ID
 func (s *SIHChaincode) ReadIncident(ctx contractapi.TransactionContextInterface, incidentID string) (*IncidentDocument, error) {
-	incidentJSON, err := s.readState(ctx, incidentID)
+	incidentJSON, err := s.readState(ctx, "incident", incidentID)
 	if err != nil {
 		return nil, err
 	}
@@ -265,6 +1616,15 @@ func (s *SIHChaincode) UpdateIncident(ctx contractapi.TransactionContextInterfac
 		IncidentSummaryHash: incidentSummaryHash,
 		CreatedAt:           existingIncident.CreatedAt, // Keep original creation date
 		Reporter:            existingIncident.Reporter,  // Keep original reporter
+		Status:              existingIncident.Status,
+		Severity:            existingIncident.Severity,
+		Category:            existingIncident.Category,
+		SubjectID:           existingIncident.SubjectID,
+		Geohash:             existingIncident.Geohash,
+		AcknowledgedAt:      existingIncident.AcknowledgedAt,
+		ResolvedAt:          existingIncident.ResolvedAt,
+		MergedInto:          existingIncident.MergedInto,
+		OwnerOrg:            existingIncident.OwnerOrg,
 		TxID:                txID,
 	}
 
@@ -273,7 +1633,7 @@ func (s *SIHChaincode) UpdateIncident(ctx contractapi.TransactionContextInterfac
 		return err
 	}
 
-	err = ctx.GetStub().PutState(incidentID, incidentJSON)
+	err = putState(ctx, "incident", incidentID, incidentJSON)
 	if err != nil {
 		return err
 	}
@@ -283,14 +1643,61 @@ func (s *SIHChaincode) UpdateIncident(ctx contractapi.TransactionContextInterfac
 	return nil
 }
 
+// UpdateIncidentStatus moves an incident forward to acknowledged, resolved or closed, stamping the
+// time it was first acknowledged and resolved
+func (s *SIHChaincode) UpdateIncidentStatus(ctx contractapi.TransactionContextInterface, incidentID, status, updater string) error {
+	next, ok := incidentStatusOrder[status]
+	if !ok {
+		return fmt.Errorf("invalid status %q", status)
+	}
+
+	incident, err := s.ReadIncident(ctx, incidentID)
+	if err != nil {
+		return err
+	}
+	// Incidents created before statuses were introduced have none and count as open
+	current := incidentStatusOrder[incident.Status]
+	if next <= current {
+		return fmt.Errorf("the incident %s cannot move from %s to %s", incidentID, incident.Status, status)
+	}
+
+	timestamp, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	if next >= incidentStatusOrder[incidentStatusAcknowledged] && incident.AcknowledgedAt == "" {
+		incident.AcknowledgedAt = timestamp
+	}
+	if next >= incidentStatusOrder[incidentStatusResolved] && incident.ResolvedAt == "" {
+		incident.ResolvedAt = timestamp
+	}
+	incident.DocType = "incident"
+	incident.Status = status
+	incident.TxID = ctx.GetStub().GetTxID()
+
+	incidentJSON, err := json.Marshal(incident)
+	if err != nil {
+		return err
+	}
+
+	err = putState(ctx, "incident", incidentID, incidentJSON)
+	if err != nil {
+		return err
+	}
+
+	ctx.GetStub().SetEvent("UpdateIncidentStatus", incidentJSON)
+	s.createAuditLog(ctx, updater, "UPDATE_INCIDENT_STATUS", incidentID)
+	return nil
+}
+
 // DeleteIncident deletes an incident record
 func (s *SIHChaincode) DeleteIncident(ctx contractapi.TransactionContextInterface, incidentID, actor string) error {
-	incidentJSON, err := s.readState(ctx, incidentID)
+	incidentJSON, err := s.readState(ctx, "incident", incidentID)
 	if err != nil {
 		return err
 	}
 
-	err = ctx.GetStub().DelState(incidentID)
+	err = delState(ctx, "incident", incidentID)
 	if err != nil {
 		return err
 	}
@@ -300,17 +1707,134 @@ func (s *SIHChaincode) DeleteIncident(ctx contractapi.TransactionContextInterfac
 	return nil
 }
 
+const maxMergedIncidentsPerTx = 20
+
+// MergeIncidents closes duplicate reports of the incident primaryID, pointing each at it. The
+// primary and duplicates must all be open and owned by the same organization. The event carries
+// the closed duplicates.
+func (s *SIHChaincode) MergeIncidents(ctx contractapi.TransactionContextInterface, primaryID, duplicateIDsJSON, actor string) error {
+	var duplicateIDs []string
+	if err := json.Unmarshal([]byte(duplicateIDsJSON), &duplicateIDs); err != nil {
+		return fmt.Errorf("invalid duplicate incident IDs: %v", err)
+	}
+	if len(duplicateIDs) == 0 || len(duplicateIDs) > maxMergedIncidentsPerTx {
+		return fmt.Errorf("between 1 and %d duplicate incidents can be merged at once", maxMergedIncidentsPerTx)
+	}
+
+	primary, err := s.ReadIncident(ctx, primaryID)
+	if err != nil {
+		return err
+	}
+	if primary.DocType != "incident" || primary.Status == incidentStatusResolved || primary.Status == "closed" {
+		return fmt.Errorf("the incident %s is not open", primaryID)
+	}
+
+	timestamp, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	txID := ctx.GetStub().GetTxID()
+	seen := map[string]bool{primaryID: true}
+	merged := make([]IncidentDocument, 0, len(duplicateIDs))
+	for _, id := range duplicateIDs {
+		if seen[id] {
+			return fmt.Errorf("the incident %s is listed more than once", id)
+		}
+		seen[id] = true
+		duplicate, err := s.ReadIncident(ctx, id)
+		if err != nil {
+			return err
+		}
+		if duplicate.DocType != "incident" || duplicate.Status == incidentStatusResolved || duplicate.Status == "closed" {
+			return fmt.Errorf("the incident %s is not open", id)
+		}
+		if duplicate.OwnerOrg != primary.OwnerOrg {
+			return fmt.Errorf("the incident %s belongs to another organization", id)
+		}
+		if duplicate.AcknowledgedAt == "" {
+			duplicate.AcknowledgedAt = timestamp
+		}
+		duplicate.ResolvedAt = timestamp
+		duplicate.Status = "closed"
+		duplicate.MergedInto = primaryID
+		duplicate.TxID = txID
+
+		duplicateJSON, err := json.Marshal(duplicate)
+		if err != nil {
+			return err
+		}
+		if err := putState(ctx, "incident", id, duplicateJSON); err != nil {
+			return err
+		}
+		merged = append(merged, *duplicate)
+	}
+
+	mergedJSON, err := json.Marshal(merged)
+	if err != nil {
+		return err
+	}
+	ctx.GetStub().SetEvent("MergeIncidents", mergedJSON)
+	s.createAuditLog(ctx, actor, "MERGE_INCIDENTS", primaryID)
+	return nil
+}
+
+// GetIncidentHistory returns every committed version of an incident, oldest first, including its
+// deletion
+func (s *SIHChaincode) GetIncidentHistory(ctx contractapi.TransactionContextInterface, incidentID string) ([]*IncidentVersion, error) {
+	modifications, err := documentHistory(ctx, "incident", incidentID)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := []*IncidentVersion{}
+	for _, modification := range modifications {
+		version := &IncidentVersion{TxID: modification.txID, Timestamp: modification.timestamp, Deleted: modification.deleted}
+		if !modification.deleted {
+			version.Incident = &IncidentDocument{}
+			if err := json.Unmarshal(modification.value, version.Incident); err != nil {
+				return nil, err
+			}
+		}
+		versions = append(versions, version)
+	}
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("the incident %s does not exist", incidentID)
+	}
+	return versions, nil
+}
+
 // ========== EVIDENCE DOCUMENT CRUD OPERATIONS ==========
 
 // CreateEvidence creates a new evidence record
 func (s *SIHChaincode) CreateEvidence(ctx contractapi.TransactionContextInterface, evidenceID, evidenceHash, incidentID, mediaType, uploadedBy string) error {
-	existing, err := s.readState(ctx, evidenceID)
+	return s.createEvidence(ctx, evidenceID, evidenceHash, incidentID, mediaType, uploadedBy, "", "")
+}
+
+// CreateEvidenceWithCID creates a new evidence record for a file held in IPFS, anchoring its content identifier
+func (s *SIHChaincode) CreateEvidenceWithCID(ctx contractapi.TransactionContextInterface, evidenceID, evidenceHash, incidentID, mediaType, uploadedBy, cid string) error {
+	if cid == "" {
+		return fmt.Errorf("cid cannot be empty")
+	}
+	return s.createEvidence(ctx, evidenceID, evidenceHash, incidentID, mediaType, uploadedBy, cid, "")
+}
+
+// CreateEncryptedEvidence creates a new evidence record for a file stored encrypted under the data
+// key keyRef. The CID is optional and, when set, addresses the encrypted file.
+func (s *SIHChaincode) CreateEncryptedEvidence(ctx contractapi.TransactionContextInterface, evidenceID, evidenceHash, incidentID, mediaType, uploadedBy, cid, keyRef string) error {
+	if keyRef == "" {
+		return fmt.Errorf("keyRef cannot be empty")
+	}
+	return s.createEvidence(ctx, evidenceID, evidenceHash, incidentID, mediaType, uploadedBy, cid, keyRef)
+}
+
+func (s *SIHChaincode) createEvidence(ctx contractapi.TransactionContextInterface, evidenceID, evidenceHash, incidentID, mediaType, uploadedBy, cid, keyRef string) error {
+	existing, err := s.readState(ctx, "evidence", evidenceID)
 	if err == nil && existing != nil {
 		return fmt.Errorf("the evidence %s already exists", evidenceID)
 	}
 
 	// Verify that the incident exists
-	_, err = s.ReadIncident(ctx, incidentID)
+	incident, err := s.ReadIncident(ctx, incidentID)
 	if err != nil {
 		return fmt.Errorf("incident %s does not exist: %w", incidentID, err)
 	}
@@ -319,13 +1843,17 @@ func (s *SIHChaincode) CreateEvidence(ctx contractapi.TransactionContextInterfac
 	txID := ctx.GetStub().GetTxID()
 
 	evidence := EvidenceDocument{
-		DocType:      "evidence",
-		EvidenceHash: evidenceHash,
-		IncidentID:   incidentID,
-		MediaType:    mediaType,
-		UploadedBy:   uploadedBy,
-		CreatedAt:    timestamp,
-		TxID:         txID,
+		DocType:          "evidence",
+		EvidenceID:       evidenceID,
+		EvidenceHash:     evidenceHash,
+		IncidentID:       incidentID,
+		MediaType:        mediaType,
+		UploadedBy:       uploadedBy,
+		CreatedAt:        timestamp,
+		CID:              cid,
+		EncryptionKeyRef: keyRef,
+		OwnerOrg:         incident.OwnerOrg, // Evidence belongs to the organization that owns the incident
+		TxID:             txID,
 	}
 
 	evidenceJSON, err := json.Marshal(evidence)
@@ -333,7 +1861,7 @@ func (s *SIHChaincode) CreateEvidence(ctx contractapi.TransactionContextInterfac
 		return err
 	}
 
-	err = ctx.GetStub().PutState(evidenceID, evidenceJSON)
+	err = putState(ctx, "evidence", evidenceID, evidenceJSON)
 	if err != nil {
 		return err
 	}
@@ -345,7 +1873,7 @@ func (s *SIHChaincode) CreateEvidence(ctx contractapi.TransactionContextInterfac
 
 // ReadEvidence returns the evidence document with given evidence ID
 func (s *SIHChaincode) ReadEvidence(ctx contractapi.TransactionContextInterface, evidenceID string) (*EvidenceDocument, error) {
-	evidenceJSON, err := s.readState(ctx, evidenceID)
+	evidenceJSON, err := s.readState(ctx, "evidence", evidenceID)
 	if err != nil {
 		return nil, err
 	}
@@ -370,12 +1898,19 @@ func (s *SIHChaincode) UpdateEvidence(ctx contractapi.TransactionContextInterfac
 
 	evidence := EvidenceDocument{
 		DocType:      "evidence",
+		EvidenceID:   evidenceID,
 		EvidenceHash: evidenceHash,
 		IncidentID:   existingEvidence.IncidentID, // Keep original incident ID
 		MediaType:    mediaType,
 		UploadedBy:   existingEvidence.UploadedBy, // Keep original uploader
 		CreatedAt:    existingEvidence.CreatedAt,  // Keep original creation date
-		TxID:         txID,
+		OwnerOrg:     existingEvidence.OwnerOrg,
+		// The stored file is not rewritten, so it stays encrypted under the same key
+		EncryptionKeyRef: existingEvidence.EncryptionKeyRef,
+		TxID:             txID,
+	}
+	if evidenceHash == existingEvidence.EvidenceHash {
+		evidence.CID = existingEvidence.CID // A CID only remains valid for unchanged content
 	}
 
 	evidenceJSON, err := json.Marshal(evidence)
@@ -383,7 +1918,7 @@ func (s *SIHChaincode) UpdateEvidence(ctx contractapi.TransactionContextInterfac
 		return err
 	}
 
-	err = ctx.GetStub().PutState(evidenceID, evidenceJSON)
+	err = putState(ctx, "evidence", evidenceID, evidenceJSON)
 	if err != nil {
 		return err
 	}
@@ -393,14 +1928,44 @@ func (s *SIHChaincode) UpdateEvidence(ctx contractapi.TransactionContextInterfac
 	return nil
 }
 
+// RekeyEvidence records that an encrypted evidence file was re-encrypted under the data key keyRef.
+// The CID is that of the re-encrypted file, or empty when the file is not content-addressed.
+func (s *SIHChaincode) RekeyEvidence(ctx contractapi.TransactionContextInterface, evidenceID, keyRef, cid, actor string) error {
+	evidence, err := s.ReadEvidence(ctx, evidenceID)
+	if err != nil {
+		return err
+	}
+	if evidence.EncryptionKeyRef == "" {
+		return fmt.Errorf("the evidence %s is not encrypted", evidenceID)
+	}
+	if keyRef == "" || keyRef == evidence.EncryptionKeyRef {
+		return fmt.Errorf("the evidence %s needs a new key reference", evidenceID)
+	}
+
+	evidence.EncryptionKeyRef = keyRef
+	evidence.CID = cid
+	evidence.TxID = ctx.GetStub().GetTxID()
+	evidenceJSON, err := json.Marshal(evidence)
+	if err != nil {
+		return err
+	}
+	if err := putState(ctx, "evidence", evidenceID, evidenceJSON); err != nil {
+		return err
+	}
+
+	ctx.GetStub().SetEvent("UpdateEvidence", evidenceJSON)
+	s.createAuditLog(ctx, actor, "REKEY_EVIDENCE", evidenceID)
+	return nil
+}
+
 // DeleteEvidence deletes an evidence record
 func (s *SIHChaincode) DeleteEvidence(ctx contractapi.TransactionContextInterface, evidenceID, actor string) error {
-	evidenceJSON, err := s.readState(ctx, evidenceID)
+	evidenceJSON, err := s.readState(ctx, "evidence", evidenceID)
 	if err != nil {
 		return err
 	}
 
-	err = ctx.GetStub().DelState(evidenceID)
+	err = delState(ctx, "evidence", evidenceID)
 	if err != nil {
 		return err
 	}
@@ -410,11 +1975,95 @@ func (s *SIHChaincode) DeleteEvidence(ctx contractapi.TransactionContextInterfac
 	return nil
 }
 
+// evidenceDerivativeKinds are the copies the gateway's media pipeline derives from evidence
+var evidenceDerivativeKinds = map[string]bool{"thumbnail": true, "preview": true, "sanitized": true}
+
+// RecordEvidenceDerivative anchors a copy derived from an evidence file. The parent hash must be
+// the evidence's anchored hash, so a copy cannot be attributed to a file that was never anchored.
+// Recording the same copy again is a no-op; a copy with another hash, made by a newer pipeline,
+// replaces it.
+func (s *SIHChaincode) RecordEvidenceDerivative(ctx contractapi.TransactionContextInterface, evidenceID, kind, parentHash, derivativeHash, mediaType, transformation, keyRef, actor string) error {
+	if !evidenceDerivativeKinds[kind] {
+		return fmt.Errorf("invalid evidence derivative kind %q", kind)
+	}
+	if len(derivativeHash) != 64 || mediaType == "" || transformation == "" {
+		return fmt.Errorf("the %s of evidence %s must have a hash, media type and transformation", kind, evidenceID)
+	}
+	evidence, err := s.ReadEvidence(ctx, evidenceID)
+	if err != nil {
+		return err
+	}
+	if parentHash != evidence.EvidenceHash {
+		return fmt.Errorf("the %s was derived from %s, not the anchored hash of evidence %s", kind, parentHash, evidenceID)
+	}
+
+	derivativeID := evidenceID + ":" + kind
+	existingJSON, err := getState(ctx, "evidence_derivative", derivativeID)
+	if err != nil {
+		return err
+	}
+	if existingJSON != nil {
+		var existing EvidenceDerivativeDocument
+		if err := json.Unmarshal(existingJSON, &existing); err != nil {
+			return err
+		}
+		if existing.ParentHash == parentHash && existing.DerivativeHash == derivativeHash {
+			return nil
+		}
+	}
+
+	createdAt, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	derivative := EvidenceDerivativeDocument{
+		DocType:          "evidence_derivative",
+		DerivativeID:     derivativeID,
+		EvidenceID:       evidenceID,
+		IncidentID:       evidence.IncidentID,
+		Kind:             kind,
+		ParentHash:       parentHash,
+		DerivativeHash:   derivativeHash,
+		MediaType:        mediaType,
+		Transformation:   transformation,
+		EncryptionKeyRef: keyRef,
+		CreatedBy:        actor,
+		CreatedAt:        createdAt,
+		OwnerOrg:         evidence.OwnerOrg,
+		TxID:             ctx.GetStub().GetTxID(),
+	}
+	derivativeJSON, err := json.Marshal(derivative)
+	if err != nil {
+		return err
+	}
+	if err := putState(ctx, "evidence_derivative", derivativeID, derivativeJSON); err != nil {
+		return err
+	}
+	ctx.GetStub().SetEvent("RecordEvidenceDerivative", derivativeJSON)
+	s.createAuditLog(ctx, actor, "RECORD_EVIDENCE_DERIVATIVE", derivativeID)
+	return nil
+}
+
+// GetEvidenceDerivatives returns the copies derived from an evidence file, by kind
+func (s *SIHChaincode) GetEvidenceDerivatives(ctx contractapi.TransactionContextInterface, evidenceID string) ([]*EvidenceDerivativeDocument, error) {
+	derivatives := []*EvidenceDerivativeDocument{}
+	err := forEachQueryResult(ctx, map[string]interface{}{"doc_type": "evidence_derivative", "evidence_id": evidenceID}, func(value []byte) error {
+		var derivative EvidenceDerivativeDocument
+		if err := json.Unmarshal(value, &derivative); err != nil {
+			return err
+		}
+		derivatives = append(derivatives, &derivative)
+		return nil
+	})
+	sort.Slice(derivatives, func(i, j int) bool { return derivatives[i].Kind < derivatives[j].Kind })
+	return derivatives, err
+}
+
 // ========== AUDIT DOCUMENT READ OPERATIONS ==========
 
 // ReadAudit returns the audit document with given audit ID
 func (s *SIHChaincode) ReadAudit(ctx contractapi.TransactionContextInterface, auditID string) (*AuditDocument, error) {
-	auditJSON, err := s.readState(ctx, auditID)
+	auditJSON, err := s.readState(ctx, "audit", auditID)
 	if err != nil {
 		return nil, err
 	}
@@ -428,11 +2077,3270 @@ func (s *SIHChaincode) ReadAudit(ctx contractapi.TransactionContextInterface, au
 	return &audit, nil
 }
 
-// ========== QUERY OPERATIONS ==========
-
-// GetEvidenceByIncident returns all evidence related to a specific incident
-func (s *SIHChaincode) GetEvidenceByIncident(ctx contractapi.TransactionContextInterface, incidentID string) ([]*EvidenceDocument, error) {
-	queryString := fmt.Sprintf(`{"selector":{"doc_type":"evidence","incident_id":"%s"}}`, incidentID)
+// ========== E-FIR OPERATIONS ==========
+
+// RecordEFIR anchors the hash of a generated FIR document against an existing incident
+func (s *SIHChaincode) RecordEFIR(ctx contractapi.TransactionContextInterface, firID, incidentID, documentHash, generatedBy string) error {
+	existing, err := s.readState(ctx, "efir", firID)
+	if err == nil && existing != nil {
+		return fmt.Errorf("the FIR %s already exists", firID)
+	}
+
+	_, err = s.ReadIncident(ctx, incidentID)
+	if err != nil {
+		return fmt.Errorf("incident %s does not exist: %w", incidentID, err)
+	}
+
+	createdAt, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	fir := EFIRDocument{
+		DocType:      "efir",
+		FIRID:        firID,
+		IncidentID:   incidentID,
+		DocumentHash: documentHash,
+		GeneratedBy:  generatedBy,
+		CreatedAt:    createdAt,
+		TxID:         ctx.GetStub().GetTxID(),
+	}
+
+	// A FIR with an allocated number takes it from the register and marks the number used
+	allocationJSON, err := getState(ctx, "fir_allocation", firID)
+	if err != nil {
+		return fmt.Errorf("failed to read from world state: %w", err)
+	}
+	if allocationJSON != nil {
+		var allocation FIRAllocationDocument
+		if err := json.Unmarshal(allocationJSON, &allocation); err != nil {
+			return err
+		}
+		if allocation.IncidentID != incidentID {
+			return fmt.Errorf("FIR number %s was allocated for incident %s", allocation.FIRNumber, allocation.IncidentID)
+		}
+		allocation.Status, allocation.RecordedAt = firAllocationRecorded, fir.CreatedAt
+		if allocationJSON, err = json.Marshal(allocation); err != nil {
+			return err
+		}
+		if err := putState(ctx, "fir_allocation", firID, allocationJSON); err != nil {
+			return err
+		}
+		fir.FIRNumber, fir.District, fir.PoliceStation = allocation.FIRNumber, allocation.District, allocation.PoliceStation
+	}
+
+	firJSON, err := json.Marshal(fir)
+	if err != nil {
+		return err
+	}
+
+	err = putState(ctx, "efir", firID, firJSON)
+	if err != nil {
+		return err
+	}
+
+	ctx.GetStub().SetEvent("RecordEFIR", firJSON)
+	s.createAuditLog(ctx, generatedBy, "GENERATE_EFIR", incidentID)
+	return nil
+}
+
+// FIR allocation states
+const (
+	firAllocationAllocated = "allocated"
+	firAllocationRecorded  = "recorded"
+)
+
+// AllocateFIRNumber takes the next number in the register of a district's police station for the
+// transaction's year and reserves it for the FIR about to be generated for the incident. Concurrent
+// allocations for one station conflict on the counter, so only one of them commits.
+func (s *SIHChaincode) AllocateFIRNumber(ctx contractapi.TransactionContextInterface, firID, incidentID, district, policeStation, allocatedBy string) (*FIRAllocationDocument, error) {
+	if district == "" || policeStation == "" {
+		return nil, fmt.Errorf("a FIR number needs a district and a police station")
+	}
+	existing, err := getState(ctx, "fir_allocation", firID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %w", err)
+	}
+	if existing != nil {
+		return nil, fmt.Errorf("a FIR number is already allocated for %s", firID)
+	}
+	if _, err := s.ReadIncident(ctx, incidentID); err != nil {
+		return nil, fmt.Errorf("incident %s does not exist: %w", incidentID, err)
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+	counterKey, err := ctx.GetStub().CreateCompositeKey("FIRSEQ", []string{district, policeStation, fmt.Sprint(now.Year())})
+	if err != nil {
+		return nil, err
+	}
+	var counter struct {
+		Sequence int `json:"sequence"`
+	}
+	counterJSON, err := ctx.GetStub().GetState(counterKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %w", err)
+	}
+	if counterJSON != nil {
+		if err := json.Unmarshal(counterJSON, &counter); err != nil {
+			return nil, err
+		}
+	}
+	counter.Sequence++
+	if counterJSON, err = json.Marshal(counter); err != nil {
+		return nil, err
+	}
+	if err := ctx.GetStub().PutState(counterKey, counterJSON); err != nil {
+		return nil, err
+	}
+
+	allocation := FIRAllocationDocument{
+		DocType:       "fir_allocation",
+		FIRID:         firID,
+		FIRNumber:     fmt.Sprintf("%04d/%d", counter.Sequence, now.Year()),
+		District:      district,
+		PoliceStation: policeStation,
+		Year:          now.Year(),
+		Sequence:      counter.Sequence,
+		IncidentID:    incidentID,
+		Status:        firAllocationAllocated,
+		AllocatedBy:   allocatedBy,
+		AllocatedAt:   now.Format(time.RFC3339),
+		TxID:          ctx.GetStub().GetTxID(),
+	}
+	allocationJSON, err := json.Marshal(allocation)
+	if err != nil {
+		return nil, err
+	}
+	if err := putState(ctx, "fir_allocation", firID, allocationJSON); err != nil {
+		return nil, err
+	}
+	ctx.GetStub().SetEvent("AllocateFIRNumber", allocationJSON)
+	s.createAuditLog(ctx, allocatedBy, "ALLOCATE_FIR_NUMBER", incidentID)
+	return &allocation, nil
+}
+
+// GetFIRRegister returns the numbers allocated in a police station's register for a year, in order
+func (s *SIHChaincode) GetFIRRegister(ctx contractapi.TransactionContextInterface, district, policeStation string, year int) ([]*FIRAllocationDocument, error) {
+	allocations := []*FIRAllocationDocument{}
+	selector := map[string]interface{}{"doc_type": "fir_allocation", "district": district, "police_station": policeStation, "year": year}
+	err := forEachQueryResult(ctx, selector, func(value []byte) error {
+		var allocation FIRAllocationDocument
+		if err := json.Unmarshal(value, &allocation); err != nil {
+			return err
+		}
+		allocations = append(allocations, &allocation)
+		return nil
+	})
+	sort.Slice(allocations, func(i, j int) bool { return allocations[i].Sequence < allocations[j].Sequence })
+	return allocations, err
+}
+
+// ReadEFIR returns the FIR record with the given ID
+func (s *SIHChaincode) ReadEFIR(ctx contractapi.TransactionContextInterface, firID string) (*EFIRDocument, error) {
+	firJSON, err := s.readState(ctx, "efir", firID)
+	if err != nil {
+		return nil, err
+	}
+
+	var fir EFIRDocument
+	err = json.Unmarshal(firJSON, &fir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fir, nil
+}
+
+// FindHashAnchor reports whether a SHA-256 is anchored as an evidence file or an e-FIR document,
+// and when it was first anchored. Nothing else about the document is returned, so the answer can be
+// passed on to callers outside the network.
+func (s *SIHChaincode) FindHashAnchor(ctx contractapi.TransactionContextInterface, hash string) (*HashAnchor, error) {
+	if len(hash) != 64 || strings.Trim(hash, "0123456789abcdef") != "" {
+		return nil, fmt.Errorf("invalid hash %q", hash)
+	}
+	anchor := &HashAnchor{}
+	for _, selector := range []map[string]interface{}{
+		{"doc_type": "evidence", "evidence_hash": hash},
+		{"doc_type": "efir", "document_hash": hash},
+	} {
+		err := forEachQueryResult(ctx, selector, func(value []byte) error {
+			var document struct {
+				CreatedAt string `json:"created_at"`
+			}
+			if err := json.Unmarshal(value, &document); err != nil {
+				return err
+			}
+			if !anchor.Anchored || document.CreatedAt < anchor.AnchoredAt {
+				anchor.Anchored, anchor.AnchoredAt = true, document.CreatedAt
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return anchor, nil
+}
+
+// ========== TIMELINE ANNEXURE OPERATIONS ==========
+
+// RecordTimelineAnnexure anchors the hash of an incident timeline annexure. Only the organization
+// that owns the incident may record one.
+func (s *SIHChaincode) RecordTimelineAnnexure(ctx contractapi.TransactionContextInterface, annexureID, incidentID, documentHash, timelineDigest string, entryCount int, generatedBy string) error {
+	for _, hash := range []string{documentHash, timelineDigest} {
+		if len(hash) != 64 || strings.Trim(hash, "0123456789abcdef") != "" {
+			return fmt.Errorf("invalid hash %q", hash)
+		}
+	}
+	if annexureID == "" || generatedBy == "" || entryCount < 0 {
+		return fmt.Errorf("annexure %q must be complete", annexureID)
+	}
+	existing, err := s.readState(ctx, "timeline_annexure", annexureID)
+	if err == nil && existing != nil {
+		return fmt.Errorf("the annexure %s already exists", annexureID)
+	}
+	incident, err := s.ReadIncident(ctx, incidentID)
+	if err != nil {
+		return err
+	}
+	org := submitterOrg(ctx)
+	if incident.OwnerOrg != "" && incident.OwnerOrg != org {
+		return fmt.Errorf("access denied: only %s can record an annexure for the incident %s", incident.OwnerOrg, incidentID)
+	}
+
+	createdAt, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	annexure := TimelineAnnexureDocument{
+		DocType:        "timeline_annexure",
+		AnnexureID:     annexureID,
+		IncidentID:     incidentID,
+		DocumentHash:   documentHash,
+		TimelineDigest: timelineDigest,
+		EntryCount:     entryCount,
+		GeneratedBy:    generatedBy,
+		CreatedAt:      createdAt,
+		OwnerOrg:       org,
+		TxID:           ctx.GetStub().GetTxID(),
+	}
+	annexureJSON, err := json.Marshal(annexure)
+	if err != nil {
+		return err
+	}
+	if err := putState(ctx, "timeline_annexure", annexureID, annexureJSON); err != nil {
+		return err
+	}
+	ctx.GetStub().SetEvent("RecordTimelineAnnexure", annexureJSON)
+	s.createAuditLog(ctx, generatedBy, "RECORD_TIMELINE_ANNEXURE", incidentID)
+	return nil
+}
+
+// ReadTimelineAnnexure returns the timeline annexure with the given ID
+func (s *SIHChaincode) ReadTimelineAnnexure(ctx contractapi.TransactionContextInterface, annexureID string) (*TimelineAnnexureDocument, error) {
+	annexureJSON, err := s.readState(ctx, "timeline_annexure", annexureID)
+	if err != nil {
+		return nil, err
+	}
+
+	var annexure TimelineAnnexureDocument
+	if err := json.Unmarshal(annexureJSON, &annexure); err != nil {
+		return nil, err
+	}
+	if annexure.DocType != "timeline_annexure" {
+		return nil, fmt.Errorf("%s is not a timeline annexure", annexureID)
+	}
+	return &annexure, nil
+}
+
+// GetTimelineAnnexuresByIncident returns the timeline annexures recorded for an incident
+func (s *SIHChaincode) GetTimelineAnnexuresByIncident(ctx contractapi.TransactionContextInterface, incidentID string) ([]*TimelineAnnexureDocument, error) {
+	annexures := []*TimelineAnnexureDocument{}
+	err := forEachQueryResult(ctx, map[string]interface{}{"doc_type": "timeline_annexure", "incident_id": incidentID}, func(value []byte) error {
+		var annexure TimelineAnnexureDocument
+		if err := json.Unmarshal(value, &annexure); err != nil {
+			return err
+		}
+		annexures = append(annexures, &annexure)
+		return nil
+	})
+	return annexures, err
+}
+
+// ========== INCIDENT ARCHIVE OPERATIONS ==========
+
+// RecordIncidentArchive anchors the digest of a resolved or closed incident's archive bundle and adds
+// an ARCHIVE_INCIDENT audit entry to the incident. The archive belongs to the incident's
+// organization whoever moves it to cold storage.
+func (s *SIHChaincode) RecordIncidentArchive(ctx contractapi.TransactionContextInterface, archiveID, incidentID, digest, objectKey string, size, proofCount int, archivedBy string) error {
+	if len(digest) != 64 || strings.Trim(digest, "0123456789abcdef") != "" {
+		return fmt.Errorf("invalid hash %q", digest)
+	}
+	if archiveID == "" || objectKey == "" || archivedBy == "" || size <= 0 || proofCount <= 0 {
+		return fmt.Errorf("archive %q must be complete", archiveID)
+	}
+	existing, err := s.readState(ctx, "incident_archive", archiveID)
+	if err == nil && existing != nil {
+		return fmt.Errorf("the archive %s already exists", archiveID)
+	}
+	incident, err := s.ReadIncident(ctx, incidentID)
+	if err != nil {
+		return err
+	}
+	if incident.Status != "resolved" && incident.Status != "closed" {
+		return fmt.Errorf("the incident %s must be resolved or closed to be archived", incidentID)
+	}
+
+	archivedAt, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	archive := IncidentArchiveDocument{
+		DocType:    "incident_archive",
+		ArchiveID:  archiveID,
+		IncidentID: incidentID,
+		Digest:     digest,
+		ObjectKey:  objectKey,
+		Size:       size,
+		ProofCount: proofCount,
+		ArchivedBy: archivedBy,
+		ArchivedAt: archivedAt,
+		OwnerOrg:   incident.OwnerOrg,
+		TxID:       ctx.GetStub().GetTxID(),
+	}
+	archiveJSON, err := json.Marshal(archive)
+	if err != nil {
+		return err
+	}
+	if err := putState(ctx, "incident_archive", archiveID, archiveJSON); err != nil {
+		return err
+	}
+	ctx.GetStub().SetEvent("RecordIncidentArchive", archiveJSON)
+	s.createAuditLog(ctx, archivedBy, "ARCHIVE_INCIDENT", incidentID)
+	return nil
+}
+
+// GetIncidentArchives returns the archives recorded for an incident, or every archive when
+// incidentID is empty
+func (s *SIHChaincode) GetIncidentArchives(ctx contractapi.TransactionContextInterface, incidentID string) ([]*IncidentArchiveDocument, error) {
+	selector := map[string]interface{}{"doc_type": "incident_archive"}
+	if incidentID != "" {
+		selector["incident_id"] = incidentID
+	}
+	archives := []*IncidentArchiveDocument{}
+	err := forEachQueryResult(ctx, selector, func(value []byte) error {
+		var archive IncidentArchiveDocument
+		if err := json.Unmarshal(value, &archive); err != nil {
+			return err
+		}
+		archives = append(archives, &archive)
+		return nil
+	})
+	return archives, err
+}
+
+// ========== SOS OPERATIONS ==========
+
+// RaiseSOS records an SOS alert for a tourist and the police unit it was routed to. The digital ID is
+// not required to exist: an alert from an unregistered or expired ID must still be recorded.
+func (s *SIHChaincode) RaiseSOS(ctx contractapi.TransactionContextInterface, alertID, digitalID, locationHash, policeUnit string) error {
+	return s.raiseSOS(ctx, alertID, digitalID, locationHash, policeUnit, "")
+}
+
+// RaiseSOSWithGeohash records an SOS alert with the coarse geohash of where it was raised, for
+// heatmaps. The exact position stays committed only through locationHash.
+func (s *SIHChaincode) RaiseSOSWithGeohash(ctx contractapi.TransactionContextInterface, alertID, digitalID, locationHash, policeUnit, geohash string) error {
+	if err := validateGeohash(geohash); err != nil {
+		return err
+	}
+	return s.raiseSOS(ctx, alertID, digitalID, locationHash, policeUnit, geohash)
+}
+
+func (s *SIHChaincode) raiseSOS(ctx contractapi.TransactionContextInterface, alertID, digitalID, locationHash, policeUnit, geohash string) error {
+	existing, err := s.readState(ctx, "sos", alertID)
+	if err == nil && existing != nil {
+		return fmt.Errorf("the SOS alert %s already exists", alertID)
+	}
+
+	raisedAt, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	sos := SOSDocument{
+		DocType:      "sos",
+		AlertID:      alertID,
+		DigitalID:    digitalID,
+		LocationHash: locationHash,
+		PoliceUnit:   policeUnit,
+		Geohash:      geohash,
+		RaisedAt:     raisedAt,
+		OwnerOrg:     submitterOrg(ctx),
+		TxID:         ctx.GetStub().GetTxID(),
+	}
+
+	sosJSON, err := json.Marshal(sos)
+	if err != nil {
+		return err
+	}
+
+	err = putState(ctx, "sos", alertID, sosJSON)
+	if err != nil {
+		return err
+	}
+
+	ctx.GetStub().SetEvent("RaiseSOS", sosJSON)
+	s.createAuditLog(ctx, digitalID, "RAISE_SOS", alertID)
+	return nil
+}
+
+// validateGeohash accepts a base32 geohash of 1 to maxLedgerGeohashPrecision characters
+func validateGeohash(geohash string) error {
+	if geohash == "" || len(geohash) > maxLedgerGeohashPrecision ||
+		strings.Trim(geohash, "0123456789bcdefghjkmnpqrstuvwxyz") != "" {
+		return fmt.Errorf("invalid geohash %q: must be 1 to %d geohash characters", geohash, maxLedgerGeohashPrecision)
+	}
+	return nil
+}
+
+// ReadSOS returns the SOS alert with the given ID
+func (s *SIHChaincode) ReadSOS(ctx contractapi.TransactionContextInterface, alertID string) (*SOSDocument, error) {
+	sosJSON, err := s.readState(ctx, "sos", alertID)
+	if err != nil {
+		return nil, err
+	}
+
+	var sos SOSDocument
+	err = json.Unmarshal(sosJSON, &sos)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sos, nil
+}
+
+// sosTriggerOutcomes maps the outcomes of a confirmation window to their audit actions
+var sosTriggerOutcomes = map[string]string{"cancelled": "CANCEL_SOS", "escalated": "ESCALATE_SOS"}
+
+// RecordSOSTrigger records the end of an SOS confirmation window. An escalated trigger must name the
+// SOS alert it raised; a cancelled one must not, since cancelling raises no alert.
+func (s *SIHChaincode) RecordSOSTrigger(ctx contractapi.TransactionContextInterface, triggerID, alertID, digitalID, outcome, triggeredAt, decidedAt, reason, actor string) error {
+	action, ok := sosTriggerOutcomes[outcome]
+	if !ok {
+		return fmt.Errorf("invalid SOS trigger outcome %q", outcome)
+	}
+	if triggerID == "" || alertID == "" || digitalID == "" {
+		return fmt.Errorf("SOS trigger %q must be complete", triggerID)
+	}
+	triggered, err := time.Parse(time.RFC3339, triggeredAt)
+	if err != nil {
+		return fmt.Errorf("invalid trigger time %q", triggeredAt)
+	}
+	decided, err := time.Parse(time.RFC3339, decidedAt)
+	if err != nil || decided.Before(triggered) {
+		return fmt.Errorf("invalid decision time %q: must not be before the trigger", decidedAt)
+	}
+	existing, err := s.readState(ctx, "sos_trigger", triggerID)
+	if err == nil && existing != nil {
+		return fmt.Errorf("the SOS trigger %s already exists", triggerID)
+	}
+	alertJSON, err := s.readState(ctx, "sos", alertID)
+	raised := err == nil && alertJSON != nil
+	if outcome == "escalated" {
+		var sos SOSDocument
+		if !raised || json.Unmarshal(alertJSON, &sos) != nil || sos.DocType != "sos" || sos.DigitalID != digitalID {
+			return fmt.Errorf("the SOS alert %s for %s does not exist", alertID, digitalID)
+		}
+	} else if raised {
+		return fmt.Errorf("the SOS alert %s was already raised and cannot be cancelled", alertID)
+	}
+
+	recordedAt, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	trigger := SOSTriggerDocument{
+		DocType:     "sos_trigger",
+		TriggerID:   triggerID,
+		AlertID:     alertID,
+		DigitalID:   digitalID,
+		Outcome:     outcome,
+		TriggeredAt: triggeredAt,
+		DecidedAt:   decidedAt,
+		Reason:      reason,
+		RecordedBy:  actor,
+		RecordedAt:  recordedAt,
+		OwnerOrg:    submitterOrg(ctx),
+		TxID:        ctx.GetStub().GetTxID(),
+	}
+	triggerJSON, err := json.Marshal(trigger)
+	if err != nil {
+		return err
+	}
+	if err := putState(ctx, "sos_trigger", triggerID, triggerJSON); err != nil {
+		return err
+	}
+	ctx.GetStub().SetEvent("RecordSOSTrigger", triggerJSON)
+	s.createAuditLog(ctx, actor, action, alertID)
+	return nil
+}
+
+// ReadSOSTrigger returns the SOS trigger with the given ID
+func (s *SIHChaincode) ReadSOSTrigger(ctx contractapi.TransactionContextInterface, triggerID string) (*SOSTriggerDocument, error) {
+	triggerJSON, err := s.readState(ctx, "sos_trigger", triggerID)
+	if err != nil {
+		return nil, err
+	}
+
+	var trigger SOSTriggerDocument
+	if err := json.Unmarshal(triggerJSON, &trigger); err != nil {
+		return nil, err
+	}
+	if trigger.DocType != "sos_trigger" {
+		return nil, fmt.Errorf("%s is not an SOS trigger", triggerID)
+	}
+	return &trigger, nil
+}
+
+// ========== ORGANIZATION ACCESS GRANT OPERATIONS ==========
+
+var accessGrantScopes = map[string]bool{"incident": true, "evidence": true}
+
+const maxGrantedEvidence = 100
+
+// GrantOrgAccess lets granteeOrg read an incident the submitting organization owns until
+// expiresAt. Evidence IDs must belong to the incident.
+func (s *SIHChaincode) GrantOrgAccess(ctx contractapi.TransactionContextInterface, grantID, granteeOrg, incidentID, scopesJSON, evidenceIDsJSON, expiresAt, reason, actor string) error {
+	if grantID == "" || granteeOrg == "" || reason == "" {
+		return fmt.Errorf("access grant %q must be complete", grantID)
+	}
+	grantor := submitterOrg(ctx)
+	if granteeOrg == grantor {
+		return fmt.Errorf("an organization cannot grant access to itself")
+	}
+	var scopes, evidenceIDs []string
+	if err := json.Unmarshal([]byte(scopesJSON), &scopes); err != nil || len(scopes) == 0 {
+		return fmt.Errorf("invalid scopes %q", scopesJSON)
+	}
+	for _, scope := range scopes {
+		if !accessGrantScopes[scope] {
+			return fmt.Errorf("invalid scope %q", scope)
+		}
+	}
+	if evidenceIDsJSON != "" {
+		if err := json.Unmarshal([]byte(evidenceIDsJSON), &evidenceIDs); err != nil || len(evidenceIDs) > maxGrantedEvidence {
+			return fmt.Errorf("invalid evidence IDs: at most %d may be listed", maxGrantedEvidence)
+		}
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	expires, err := time.Parse(time.RFC3339, expiresAt)
+	if err != nil || !expires.After(now) {
+		return fmt.Errorf("invalid expiry %q: must be in the future", expiresAt)
+	}
+	existing, err := s.readState(ctx, "access_grant", grantID)
+	if err == nil && existing != nil {
+		return fmt.Errorf("the access grant %s already exists", grantID)
+	}
+
+	incident, err := s.ReadIncident(ctx, incidentID)
+	if err != nil {
+		return err
+	}
+	if incident.DocType != "incident" || incident.OwnerOrg != grantor {
+		return fmt.Errorf("the incident %s does not belong to %s", incidentID, grantor)
+	}
+	for _, evidenceID := range evidenceIDs {
+		evidence, err := s.ReadEvidence(ctx, evidenceID)
+		if err != nil || evidence.IncidentID != incidentID {
+			return fmt.Errorf("the evidence %s does not belong to the incident %s", evidenceID, incidentID)
+		}
+	}
+
+	grant := AccessGrantDocument{
+		DocType:     "access_grant",
+		GrantID:     grantID,
+		GrantorOrg:  grantor,
+		GranteeOrg:  granteeOrg,
+		IncidentID:  incidentID,
+		Scopes:      scopes,
+		EvidenceIDs: evidenceIDs,
+		Reason:      reason,
+		GrantedBy:   actor,
+		GrantedAt:   now.Format(time.RFC3339),
+		ExpiresAt:   expiresAt,
+		TxID:        ctx.GetStub().GetTxID(),
+	}
+	grantJSON, err := json.Marshal(grant)
+	if err != nil {
+		return err
+	}
+	if err := putState(ctx, "access_grant", grantID, grantJSON); err != nil {
+		return err
+	}
+	ctx.GetStub().SetEvent("GrantOrgAccess", grantJSON)
+	s.createAuditLog(ctx, actor, "GRANT_ORG_ACCESS", incidentID)
+	return nil
+}
+
+// RevokeOrgAccess ends an access grant before it expires. Only the grantor organization may.
+func (s *SIHChaincode) RevokeOrgAccess(ctx contractapi.TransactionContextInterface, grantID, actor string) error {
+	grant, err := s.ReadAccessGrant(ctx, grantID)
+	if err != nil {
+		return err
+	}
+	if grant.GrantorOrg != submitterOrg(ctx) {
+		return fmt.Errorf("only %s can revoke the access grant %s", grant.GrantorOrg, grantID)
+	}
+	if grant.RevokedAt != "" {
+		return fmt.Errorf("the access grant %s is already revoked", grantID)
+	}
+	if grant.RevokedAt, err = txTimestamp(ctx); err != nil {
+		return err
+	}
+	grant.RevokedBy = actor
+	grant.TxID = ctx.GetStub().GetTxID()
+	grantJSON, err := json.Marshal(grant)
+	if err != nil {
+		return err
+	}
+	if err := putState(ctx, "access_grant", grantID, grantJSON); err != nil {
+		return err
+	}
+	ctx.GetStub().SetEvent("RevokeOrgAccess", grantJSON)
+	s.createAuditLog(ctx, actor, "REVOKE_ORG_ACCESS", grant.IncidentID)
+	return nil
+}
+
+// ReadAccessGrant returns the access grant with the given ID
+func (s *SIHChaincode) ReadAccessGrant(ctx contractapi.TransactionContextInterface, grantID string) (*AccessGrantDocument, error) {
+	grantJSON, err := s.readState(ctx, "access_grant", grantID)
+	if err != nil {
+		return nil, err
+	}
+
+	var grant AccessGrantDocument
+	if err := json.Unmarshal(grantJSON, &grant); err != nil {
+		return nil, err
+	}
+	if grant.DocType != "access_grant" {
+		return nil, fmt.Errorf("%s is not an access grant", grantID)
+	}
+	return &grant, nil
+}
+
+// ========== DISPATCH ASSIGNMENT OPERATIONS ==========
+
+// CreateAssignment assigns a responder unit to an existing SOS alert or incident. subjectType is sos
+// or incident, and ackDeadline is when the assignment escalates if the unit has not acknowledged it.
+func (s *SIHChaincode) CreateAssignment(ctx contractapi.TransactionContextInterface, assignmentID, subjectType, subjectID, unitID, unitKind string, attempt int, distanceKm float64, ackDeadline, actor string) error {
+	docType, ok := assignmentSubjects[subjectType]
+	if !ok {
+		return fmt.Errorf("invalid subject type %q", subjectType)
+	}
+	if !unitKinds[unitKind] {
+		return fmt.Errorf("invalid unit kind %q", unitKind)
+	}
+	if unitID == "" || attempt < 1 || distanceKm < 0 {
+		return fmt.Errorf("assignment %s needs a unit, a positive attempt and a distance", assignmentID)
+	}
+	if _, err := time.Parse(time.RFC3339, ackDeadline); err != nil {
+		return fmt.Errorf("invalid acknowledgement deadline %q", ackDeadline)
+	}
+	existing, err := s.readState(ctx, "assignment", assignmentID)
+	if err == nil && existing != nil {
+		return fmt.Errorf("the assignment %s already exists", assignmentID)
+	}
+	subjectJSON, err := s.readState(ctx, docType, subjectID)
+	if err != nil {
+		return err
+	}
+	var subject struct {
+		DocType string `json:"doc_type"`
+	}
+	if err := json.Unmarshal(subjectJSON, &subject); err != nil || subject.DocType != docType {
+		return fmt.Errorf("%s is not a %s", subjectID, subjectType)
+	}
+
+	assignedAt, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	assignment := AssignmentDocument{
+		DocType:      "assignment",
+		AssignmentID: assignmentID,
+		SubjectType:  subjectType,
+		SubjectID:    subjectID,
+		UnitID:       unitID,
+		UnitKind:     unitKind,
+		Attempt:      attempt,
+		DistanceKm:   distanceKm,
+		Status:       assignmentStatusAssigned,
+		AssignedAt:   assignedAt,
+		AckDeadline:  ackDeadline,
+		OwnerOrg:     submitterOrg(ctx),
+		TxID:         ctx.GetStub().GetTxID(),
+	}
+	return s.putAssignment(ctx, assignment, "CreateAssignment", actor, "CREATE_ASSIGNMENT")
+}
+
+// AcknowledgeAssignment records that the assigned unit has accepted the assignment
+func (s *SIHChaincode) AcknowledgeAssignment(ctx contractapi.TransactionContextInterface, assignmentID, unitID string) error {
+	assignment, err := s.ReadAssignment(ctx, assignmentID)
+	if err != nil {
+		return err
+	}
+	if assignment.UnitID != unitID {
+		return fmt.Errorf("the assignment %s is not assigned to %s", assignmentID, unitID)
+	}
+	if assignment.Status != assignmentStatusAssigned {
+		return fmt.Errorf("the assignment %s cannot be acknowledged once %s", assignmentID, assignment.Status)
+	}
+	assignment.Status = assignmentStatusAcknowledged
+	if assignment.AcknowledgedAt, err = txTimestamp(ctx); err != nil {
+		return err
+	}
+	assignment.AcknowledgedBy = unitID
+	assignment.TxID = ctx.GetStub().GetTxID()
+	return s.putAssignment(ctx, *assignment, "AcknowledgeAssignment", unitID, "ACKNOWLEDGE_ASSIGNMENT")
+}
+
+// EscalateAssignment closes an unacknowledged assignment. escalatedTo names the assignment that
+// replaces it, or is empty when no other unit was available and the case went to the control room.
+func (s *SIHChaincode) EscalateAssignment(ctx contractapi.TransactionContextInterface, assignmentID, escalatedTo, actor string) error {
+	assignment, err := s.ReadAssignment(ctx, assignmentID)
+	if err != nil {
+		return err
+	}
+	if assignment.Status != assignmentStatusAssigned {
+		return fmt.Errorf("the assignment %s cannot be escalated once %s", assignmentID, assignment.Status)
+	}
+	assignment.Status = assignmentStatusEscalated
+	if assignment.EscalatedAt, err = txTimestamp(ctx); err != nil {
+		return err
+	}
+	assignment.EscalatedTo = escalatedTo
+	assignment.TxID = ctx.GetStub().GetTxID()
+	return s.putAssignment(ctx, *assignment, "EscalateAssignment", actor, "ESCALATE_ASSIGNMENT")
+}
+
+// DeclineAssignment records that the assigned unit has refused the assignment. escalatedTo names the
+// assignment that replaces it, or is empty when the case went to the control room.
+func (s *SIHChaincode) DeclineAssignment(ctx contractapi.TransactionContextInterface, assignmentID, unitID, reason, escalatedTo string) error {
+	assignment, err := s.ReadAssignment(ctx, assignmentID)
+	if err != nil {
+		return err
+	}
+	if assignment.UnitID != unitID {
+		return fmt.Errorf("the assignment %s is not assigned to %s", assignmentID, unitID)
+	}
+	if assignment.Status != assignmentStatusAssigned {
+		return fmt.Errorf("the assignment %s cannot be declined once %s", assignmentID, assignment.Status)
+	}
+	assignment.Status = assignmentStatusDeclined
+	if assignment.DeclinedAt, err = txTimestamp(ctx); err != nil {
+		return err
+	}
+	assignment.DeclineReason = reason
+	assignment.EscalatedTo = escalatedTo
+	assignment.TxID = ctx.GetStub().GetTxID()
+	return s.putAssignment(ctx, *assignment, "DeclineAssignment", unitID, "DECLINE_ASSIGNMENT")
+}
+
+func (s *SIHChaincode) putAssignment(ctx contractapi.TransactionContextInterface, assignment AssignmentDocument, eventName, actor, action string) error {
+	assignmentJSON, err := json.Marshal(assignment)
+	if err != nil {
+		return err
+	}
+	if err := putState(ctx, "assignment", assignment.AssignmentID, assignmentJSON); err != nil {
+		return err
+	}
+	ctx.GetStub().SetEvent(eventName, assignmentJSON)
+	s.createAuditLog(ctx, actor, action, assignment.AssignmentID)
+	return nil
+}
+
+// ReadAssignment returns the assignment with the given ID
+func (s *SIHChaincode) ReadAssignment(ctx contractapi.TransactionContextInterface, assignmentID string) (*AssignmentDocument, error) {
+	assignmentJSON, err := s.readState(ctx, "assignment", assignmentID)
+	if err != nil {
+		return nil, err
+	}
+
+	var assignment AssignmentDocument
+	if err := json.Unmarshal(assignmentJSON, &assignment); err != nil {
+		return nil, err
+	}
+	if assignment.DocType != "assignment" {
+		return nil, fmt.Errorf("%s is not an assignment", assignmentID)
+	}
+	return &assignment, nil
+}
+
+// GetAssignmentsBySubject returns every assignment made for an SOS alert or incident
+func (s *SIHChaincode) GetAssignmentsBySubject(ctx contractapi.TransactionContextInterface, subjectID string) ([]*AssignmentDocument, error) {
+	assignments := []*AssignmentDocument{}
+	err := forEachQueryResult(ctx, map[string]interface{}{"doc_type": "assignment", "subject_id": subjectID}, func(value []byte) error {
+		var assignment AssignmentDocument
+		if err := json.Unmarshal(value, &assignment); err != nil {
+			return err
+		}
+		assignments = append(assignments, &assignment)
+		return nil
+	})
+	return assignments, err
+}
+
+// ========== LOCATION ANCHOR OPERATIONS ==========
+
+const maxLocationAnchorsPerTx = 500
+
+// AnchorLocationDigests records a window's location digests for many tourists in one transaction.
+// anchorsJSON is an array of LocationAnchorDocument with anchor_id, digital_id, digest, ping_count,
+// first_ping_at and last_ping_at set. Anchors are routine and frequent, so they raise no event or
+// audit entry; the anchor documents are the record.
+func (s *SIHChaincode) AnchorLocationDigests(ctx contractapi.TransactionContextInterface, anchorsJSON string) (int, error) {
+	var anchors []LocationAnchorDocument
+	if err := json.Unmarshal([]byte(anchorsJSON), &anchors); err != nil {
+		return 0, fmt.Errorf("invalid anchors: %v", err)
+	}
+	if len(anchors) == 0 || len(anchors) > maxLocationAnchorsPerTx {
+		return 0, fmt.Errorf("anchors must contain between 1 and %d entries", maxLocationAnchorsPerTx)
+	}
+
+	anchoredAt, err := txTimestamp(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for _, anchor := range anchors {
+		if anchor.AnchorID == "" || anchor.DigitalID == "" || anchor.Digest == "" || anchor.PingCount <= 0 {
+			return 0, fmt.Errorf("anchor %q must be complete with a positive ping_count", anchor.AnchorID)
+		}
+		existing, err := s.readState(ctx, "location_anchor", anchor.AnchorID)
+		if err == nil && existing != nil {
+			return 0, fmt.Errorf("the location anchor %s already exists", anchor.AnchorID)
+		}
+
+		anchor.DocType = "location_anchor"
+		anchor.AnchoredAt = anchoredAt
+		anchor.TxID = ctx.GetStub().GetTxID()
+		anchorJSON, err := json.Marshal(anchor)
+		if err != nil {
+			return 0, err
+		}
+		if err := putState(ctx, "location_anchor", anchor.AnchorID, anchorJSON); err != nil {
+			return 0, err
+		}
+	}
+	return len(anchors), nil
+}
+
+// GetLocationAnchors returns the location anchors recorded for a tourist
+func (s *SIHChaincode) GetLocationAnchors(ctx contractapi.TransactionContextInterface, digitalID string) ([]*LocationAnchorDocument, error) {
+	anchors := []*LocationAnchorDocument{}
+	err := forEachQueryResult(ctx, map[string]interface{}{"doc_type": "location_anchor", "digital_id": digitalID}, func(value []byte) error {
+		var anchor LocationAnchorDocument
+		if err := json.Unmarshal(value, &anchor); err != nil {
+			return err
+		}
+		anchors = append(anchors, &anchor)
+		return nil
+	})
+	return anchors, err
+}
+
+// ========== SAFETY SCORE ANCHOR OPERATIONS ==========
+
+const maxSafetyAnchorsPerTx = 500
+
+var safetyLevels = map[string]bool{"safe": true, "caution": true, "danger": true}
+
+// AnchorSafetyScores records a window's safety scores for many tourists in one transaction.
+// anchorsJSON is an array of SafetyAnchorDocument with anchor_id, digital_id, score, level, digest
+// and computed_at set. Like location anchors, they raise no event or audit entry.
+func (s *SIHChaincode) AnchorSafetyScores(ctx contractapi.TransactionContextInterface, anchorsJSON string) (int, error) {
+	var anchors []SafetyAnchorDocument
+	if err := json.Unmarshal([]byte(anchorsJSON), &anchors); err != nil {
+		return 0, fmt.Errorf("invalid anchors: %v", err)
+	}
+	if len(anchors) == 0 || len(anchors) > maxSafetyAnchorsPerTx {
+		return 0, fmt.Errorf("anchors must contain between 1 and %d entries", maxSafetyAnchorsPerTx)
+	}
+
+	anchoredAt, err := txTimestamp(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for _, anchor := range anchors {
+		if anchor.AnchorID == "" || anchor.DigitalID == "" || anchor.Digest == "" || anchor.ComputedAt == "" {
+			return 0, fmt.Errorf("anchor %q must be complete", anchor.AnchorID)
+		}
+		if anchor.Score < 0 || anchor.Score > 100 || !safetyLevels[anchor.Level] {
+			return 0, fmt.Errorf("anchor %s must have a score between 0 and 100 and a level of safe, caution or danger", anchor.AnchorID)
+		}
+		existing, err := s.readState(ctx, "safety_anchor", anchor.AnchorID)
+		if err == nil && existing != nil {
+			return 0, fmt.Errorf("the safety anchor %s already exists", anchor.AnchorID)
+		}
+
+		anchor.DocType = "safety_anchor"
+		anchor.AnchoredAt = anchoredAt
+		anchor.TxID = ctx.GetStub().GetTxID()
+		anchorJSON, err := json.Marshal(anchor)
+		if err != nil {
+			return 0, err
+		}
+		if err := putState(ctx, "safety_anchor", anchor.AnchorID, anchorJSON); err != nil {
+			return 0, err
+		}
+	}
+	return len(anchors), nil
+}
+
+// GetSafetyAnchors returns the safety score anchors recorded for a tourist
+func (s *SIHChaincode) GetSafetyAnchors(ctx contractapi.TransactionContextInterface, digitalID string) ([]*SafetyAnchorDocument, error) {
+	anchors := []*SafetyAnchorDocument{}
+	err := forEachQueryResult(ctx, map[string]interface{}{"doc_type": "safety_anchor", "digital_id": digitalID}, func(value []byte) error {
+		var anchor SafetyAnchorDocument
+		if err := json.Unmarshal(value, &anchor); err != nil {
+			return err
+		}
+		anchors = append(anchors, &anchor)
+		return nil
+	})
+	return anchors, err
+}
+
+// ========== MODEL DECISION OPERATIONS ==========
+
+const maxModelDecisionsPerTx = 500
+
+// AnchorModelDecisions records a batch of model decisions. A decision already recorded with the same
+// feature hash is skipped, so a batch retried after an unclear commit records the rest.
+func (s *SIHChaincode) AnchorModelDecisions(ctx contractapi.TransactionContextInterface, decisionsJSON string) (int, error) {
+	var decisions []ModelDecisionDocument
+	if err := json.Unmarshal([]byte(decisionsJSON), &decisions); err != nil {
+		return 0, fmt.Errorf("invalid decisions: %v", err)
+	}
+	if len(decisions) == 0 || len(decisions) > maxModelDecisionsPerTx {
+		return 0, fmt.Errorf("decisions must contain between 1 and %d entries", maxModelDecisionsPerTx)
+	}
+
+	anchoredAt, err := txTimestamp(ctx)
+	if err != nil {
+		return 0, err
+	}
+	anchored := 0
+	for _, decision := range decisions {
+		if decision.DecisionID == "" || decision.AlertID == "" || decision.AlertType == "" || decision.DigitalID == "" ||
+			decision.Model == "" || decision.ModelVersion == "" || decision.Decision == "" {
+			return 0, fmt.Errorf("decision %q must be complete", decision.DecisionID)
+		}
+		if len(decision.FeatureHash) != 64 {
+			return 0, fmt.Errorf("decision %s must have a hex SHA-256 feature hash", decision.DecisionID)
+		}
+		if _, err := time.Parse(time.RFC3339, decision.DecidedAt); err != nil {
+			return 0, fmt.Errorf("invalid decision time %q", decision.DecidedAt)
+		}
+		existingJSON, err := getState(ctx, "model_decision", decision.DecisionID)
+		if err != nil {
+			return 0, err
+		}
+		if existingJSON != nil {
+			var existing ModelDecisionDocument
+			if err := json.Unmarshal(existingJSON, &existing); err != nil {
+				return 0, err
+			}
+			if existing.FeatureHash != decision.FeatureHash {
+				return 0, fmt.Errorf("the decision %s already exists with other features", decision.DecisionID)
+			}
+			continue
+		}
+
+		decision.DocType = "model_decision"
+		decision.AnchoredAt = anchoredAt
+		decision.TxID = ctx.GetStub().GetTxID()
+		decisionJSON, err := json.Marshal(decision)
+		if err != nil {
+			return 0, err
+		}
+		if err := putState(ctx, "model_decision", decision.DecisionID, decisionJSON); err != nil {
+			return 0, err
+		}
+		anchored++
+	}
+	return anchored, nil
+}
+
+// ReadModelDecision returns the model decision with the given ID
+func (s *SIHChaincode) ReadModelDecision(ctx contractapi.TransactionContextInterface, decisionID string) (*ModelDecisionDocument, error) {
+	decisionJSON, err := s.readState(ctx, "model_decision", decisionID)
+	if err != nil {
+		return nil, err
+	}
+	var decision ModelDecisionDocument
+	if err := json.Unmarshal(decisionJSON, &decision); err != nil {
+		return nil, err
+	}
+	return &decision, nil
+}
+
+// GetModelDecisions returns the model decisions recorded for a tourist
+func (s *SIHChaincode) GetModelDecisions(ctx contractapi.TransactionContextInterface, digitalID string) ([]*ModelDecisionDocument, error) {
+	decisions := []*ModelDecisionDocument{}
+	err := forEachQueryResult(ctx, map[string]interface{}{"doc_type": "model_decision", "digital_id": digitalID}, func(value []byte) error {
+		var decision ModelDecisionDocument
+		if err := json.Unmarshal(value, &decision); err != nil {
+			return err
+		}
+		decisions = append(decisions, &decision)
+		return nil
+	})
+	return decisions, err
+}
+
+// ========== ITINERARY ANCHOR OPERATIONS ==========
+
+// AnchorItinerary records the digest of an itinerary a tourist declared. An itinerary is anchored
+// once; declaring a changed route anchors a new digest under a new anchor ID.
+func (s *SIHChaincode) AnchorItinerary(ctx contractapi.TransactionContextInterface, anchorID, digitalID, digest string, stopCount int, startsAt, endsAt, actor string) error {
+	if anchorID == "" || digest == "" || stopCount < 2 {
+		return fmt.Errorf("itinerary anchor %q must be complete with at least 2 stops", anchorID)
+	}
+	if _, err := time.Parse(time.RFC3339, startsAt); err != nil {
+		return fmt.Errorf("invalid start time %q", startsAt)
+	}
+	if endsAt <= startsAt {
+		return fmt.Errorf("the itinerary must end after %s", startsAt)
+	}
+	if _, err := s.ReadDID(ctx, digitalID); err != nil {
+		return err
+	}
+	existing, err := s.readState(ctx, "itinerary_anchor", anchorID)
+	if err == nil && existing != nil {
+		return fmt.Errorf("the itinerary anchor %s already exists", anchorID)
+	}
+
+	anchoredAt, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	anchor := ItineraryAnchorDocument{
+		DocType:    "itinerary_anchor",
+		AnchorID:   anchorID,
+		DigitalID:  digitalID,
+		Digest:     digest,
+		StopCount:  stopCount,
+		StartsAt:   startsAt,
+		EndsAt:     endsAt,
+		AnchoredBy: actor,
+		AnchoredAt: anchoredAt,
+		OwnerOrg:   submitterOrg(ctx),
+		TxID:       ctx.GetStub().GetTxID(),
+	}
+	anchorJSON, err := json.Marshal(anchor)
+	if err != nil {
+		return err
+	}
+	if err := putState(ctx, "itinerary_anchor", anchorID, anchorJSON); err != nil {
+		return err
+	}
+	ctx.GetStub().SetEvent("AnchorItinerary", anchorJSON)
+	s.createAuditLog(ctx, actor, "ANCHOR_ITINERARY", anchorID)
+	return nil
+}
+
+// ReadItineraryAnchor returns the itinerary anchor with the given ID
+func (s *SIHChaincode) ReadItineraryAnchor(ctx contractapi.TransactionContextInterface, anchorID string) (*ItineraryAnchorDocument, error) {
+	anchorJSON, err := s.readState(ctx, "itinerary_anchor", anchorID)
+	if err != nil {
+		return nil, err
+	}
+
+	var anchor ItineraryAnchorDocument
+	if err := json.Unmarshal(anchorJSON, &anchor); err != nil {
+		return nil, err
+	}
+	if anchor.DocType != "itinerary_anchor" {
+		return nil, fmt.Errorf("%s is not an itinerary anchor", anchorID)
+	}
+	return &anchor, nil
+}
+
+// GetItineraryAnchors returns the itinerary anchors recorded for a tourist
+func (s *SIHChaincode) GetItineraryAnchors(ctx contractapi.TransactionContextInterface, digitalID string) ([]*ItineraryAnchorDocument, error) {
+	anchors := []*ItineraryAnchorDocument{}
+	err := forEachQueryResult(ctx, map[string]interface{}{"doc_type": "itinerary_anchor", "digital_id": digitalID}, func(value []byte) error {
+		var anchor ItineraryAnchorDocument
+		if err := json.Unmarshal(value, &anchor); err != nil {
+			return err
+		}
+		anchors = append(anchors, &anchor)
+		return nil
+	})
+	return anchors, err
+}
+
+// ========== ACCOMMODATION STAY OPERATIONS ==========
+
+const (
+	stayStatusCheckedIn  = "checked_in"
+	stayStatusCheckedOut = "checked_out"
+)
+
+// CheckInStay records that a tourist checked in at a provider's property. recordHash is the SHA-256
+// of the provider's guest register entry.
+func (s *SIHChaincode) CheckInStay(ctx contractapi.TransactionContextInterface, stayID, digitalID, providerID, propertyID, geohash, checkedInAt, recordHash, actor string) error {
+	if stayID == "" || providerID == "" || propertyID == "" || recordHash == "" {
+		return fmt.Errorf("stay %q must be complete", stayID)
+	}
+	if err := validateGeohash(geohash); err != nil {
+		return err
+	}
+	if _, err := time.Parse(time.RFC3339, checkedInAt); err != nil {
+		return fmt.Errorf("invalid check-in time %q", checkedInAt)
+	}
+	if _, err := s.ReadDID(ctx, digitalID); err != nil {
+		return err
+	}
+	existing, err := s.readState(ctx, "stay", stayID)
+	if err == nil && existing != nil {
+		return fmt.Errorf("the stay %s already exists", stayID)
+	}
+
+	stay := StayDocument{
+		DocType:     "stay",
+		StayID:      stayID,
+		DigitalID:   digitalID,
+		ProviderID:  providerID,
+		PropertyID:  propertyID,
+		Geohash:     geohash,
+		Status:      stayStatusCheckedIn,
+		CheckedInAt: checkedInAt,
+		RecordHash:  recordHash,
+		ReportedBy:  actor,
+		OwnerOrg:    submitterOrg(ctx),
+		TxID:        ctx.GetStub().GetTxID(),
+	}
+	return s.putStay(ctx, stay, "CheckInStay", actor, "CHECK_IN_STAY")
+}
+
+// CheckOutStay closes a stay. Only the provider that reported the check-in may report the check-out,
+// and recordHash replaces the register hash with that of the completed entry.
+func (s *SIHChaincode) CheckOutStay(ctx contractapi.TransactionContextInterface, stayID, providerID, checkedOutAt, recordHash, actor string) error {
+	stay, err := s.ReadStay(ctx, stayID)
+	if err != nil {
+		return err
+	}
+	if stay.ProviderID != providerID {
+		return fmt.Errorf("the stay %s was not reported by %s", stayID, providerID)
+	}
+	if stay.Status != stayStatusCheckedIn {
+		return fmt.Errorf("the stay %s cannot be checked out once %s", stayID, stay.Status)
+	}
+	if _, err := time.Parse(time.RFC3339, checkedOutAt); err != nil {
+		return fmt.Errorf("invalid check-out time %q", checkedOutAt)
+	}
+	if checkedOutAt < stay.CheckedInAt {
+		return fmt.Errorf("the stay %s cannot end before its check-in at %s", stayID, stay.CheckedInAt)
+	}
+	if recordHash == "" {
+		return fmt.Errorf("the check-out of stay %s needs a record hash", stayID)
+	}
+	stay.Status = stayStatusCheckedOut
+	stay.CheckedOutAt = checkedOutAt
+	stay.RecordHash = recordHash
+	stay.TxID = ctx.GetStub().GetTxID()
+	return s.putStay(ctx, *stay, "CheckOutStay", actor, "CHECK_OUT_STAY")
+}
+
+func (s *SIHChaincode) putStay(ctx contractapi.TransactionContextInterface, stay StayDocument, eventName, actor, action string) error {
+	stayJSON, err := json.Marshal(stay)
+	if err != nil {
+		return err
+	}
+	if err := putState(ctx, "stay", stay.StayID, stayJSON); err != nil {
+		return err
+	}
+	ctx.GetStub().SetEvent(eventName, stayJSON)
+	s.createAuditLog(ctx, actor, action, stay.StayID)
+	return nil
+}
+
+// ReadStay returns the stay with the given ID
+func (s *SIHChaincode) ReadStay(ctx contractapi.TransactionContextInterface, stayID string) (*StayDocument, error) {
+	stayJSON, err := s.readState(ctx, "stay", stayID)
+	if err != nil {
+		return nil, err
+	}
+
+	var stay StayDocument
+	if err := json.Unmarshal(stayJSON, &stay); err != nil {
+		return nil, err
+	}
+	if stay.DocType != "stay" {
+		return nil, fmt.Errorf("%s is not a stay", stayID)
+	}
+	return &stay, nil
+}
+
+// GetStaysByDID returns the stays reported for a tourist
+func (s *SIHChaincode) GetStaysByDID(ctx contractapi.TransactionContextInterface, digitalID string) ([]*StayDocument, error) {
+	stays := []*StayDocument{}
+	err := forEachQueryResult(ctx, map[string]interface{}{"doc_type": "stay", "digital_id": digitalID}, func(value []byte) error {
+		var stay StayDocument
+		if err := json.Unmarshal(value, &stay); err != nil {
+			return err
+		}
+		stays = append(stays, &stay)
+		return nil
+	})
+	return stays, err
+}
+
+// ========== ZONE PERMIT OPERATIONS ==========
+
+const (
+	permitStatusActive  = "active"
+	permitStatusRevoked = "revoked"
+)
+
+// IssuePermit records an approved permit for a tourist to enter a zone. The permit cannot outlast
+// the tourist's DID.
+func (s *SIHChaincode) IssuePermit(ctx contractapi.TransactionContextInterface, permitID, digitalID, zoneID, purpose, validFrom, validUntil, actor string) error {
+	if permitID == "" || purpose == "" || actor == "" {
+		return fmt.Errorf("permit %q must be complete", permitID)
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	from, err := time.Parse(time.RFC3339, validFrom)
+	if err != nil {
+		return fmt.Errorf("invalid validity start %q", validFrom)
+	}
+	until, err := time.Parse(time.RFC3339, validUntil)
+	if err != nil || !until.After(from) || !until.After(now) {
+		return fmt.Errorf("invalid validity end %q: must be in the future and after %s", validUntil, validFrom)
+	}
+	did, err := s.ReadDID(ctx, digitalID)
+	if err != nil {
+		return err
+	}
+	if expires, err := time.Parse(time.RFC3339, did.ExpiresAt); err == nil && until.After(expires) {
+		return fmt.Errorf("the permit %s cannot outlast the DID %s, which expires at %s", permitID, digitalID, did.ExpiresAt)
+	}
+	if _, err := s.ReadZone(ctx, zoneID); err != nil {
+		return err
+	}
+	existing, err := s.readState(ctx, "permit", permitID)
+	if err == nil && existing != nil {
+		return fmt.Errorf("the permit %s already exists", permitID)
+	}
+
+	permit := PermitDocument{
+		DocType:    "permit",
+		PermitID:   permitID,
+		DigitalID:  digitalID,
+		ZoneID:     zoneID,
+		Purpose:    purpose,
+		ValidFrom:  validFrom,
+		ValidUntil: validUntil,
+		Status:     permitStatusActive,
+		ApprovedBy: actor,
+		IssuedAt:   now.Format(time.RFC3339),
+		OwnerOrg:   submitterOrg(ctx),
+		TxID:       ctx.GetStub().GetTxID(),
+	}
+	return s.putPermit(ctx, permit, "IssuePermit", actor, "ISSUE_PERMIT")
+}
+
+// RevokePermit withdraws a permit before it lapses. Only the issuing organization may.
+func (s *SIHChaincode) RevokePermit(ctx contractapi.TransactionContextInterface, permitID, reason, actor string) error {
+	permit, err := s.ReadPermit(ctx, permitID)
+	if err != nil {
+		return err
+	}
+	if permit.OwnerOrg != submitterOrg(ctx) {
+		return fmt.Errorf("only %s can revoke the permit %s", permit.OwnerOrg, permitID)
+	}
+	if permit.Status == permitStatusRevoked {
+		return fmt.Errorf("the permit %s is already revoked", permitID)
+	}
+	if reason == "" {
+		return fmt.Errorf("the revocation of permit %s needs a reason", permitID)
+	}
+	if permit.RevokedAt, err = txTimestamp(ctx); err != nil {
+		return err
+	}
+	permit.Status = permitStatusRevoked
+	permit.RevokedBy = actor
+	permit.RevocationReason = reason
+	permit.TxID = ctx.GetStub().GetTxID()
+	return s.putPermit(ctx, *permit, "RevokePermit", actor, "REVOKE_PERMIT")
+}
+
+func (s *SIHChaincode) putPermit(ctx contractapi.TransactionContextInterface, permit PermitDocument, eventName, actor, action string) error {
+	permitJSON, err := json.Marshal(permit)
+	if err != nil {
+		return err
+	}
+	if err := putState(ctx, "permit", permit.PermitID, permitJSON); err != nil {
+		return err
+	}
+	ctx.GetStub().SetEvent(eventName, permitJSON)
+	s.createAuditLog(ctx, actor, action, permit.PermitID)
+	return nil
+}
+
+// ReadPermit returns the permit with the given ID
+func (s *SIHChaincode) ReadPermit(ctx contractapi.TransactionContextInterface, permitID string) (*PermitDocument, error) {
+	permitJSON, err := s.readState(ctx, "permit", permitID)
+	if err != nil {
+		return nil, err
+	}
+
+	var permit PermitDocument
+	if err := json.Unmarshal(permitJSON, &permit); err != nil {
+		return nil, err
+	}
+	if permit.DocType != "permit" {
+		return nil, fmt.Errorf("%s is not a permit", permitID)
+	}
+	return &permit, nil
+}
+
+// GetPermitsByDID returns the permits issued to a tourist
+func (s *SIHChaincode) GetPermitsByDID(ctx contractapi.TransactionContextInterface, digitalID string) ([]*PermitDocument, error) {
+	permits := []*PermitDocument{}
+	err := forEachQueryResult(ctx, map[string]interface{}{"doc_type": "permit", "digital_id": digitalID}, func(value []byte) error {
+		var permit PermitDocument
+		if err := json.Unmarshal(value, &permit); err != nil {
+			return err
+		}
+		permits = append(permits, &permit)
+		return nil
+	})
+	return permits, err
+}
+
+// ========== INSURANCE CLAIM REFERENCE OPERATIONS ==========
+
+const maxClaimEvidence = 100
+
+// claimEvidenceDigest is the SHA-256 over the evidence in ID order, one "id:hash" line each
+func claimEvidenceDigest(evidence []ClaimEvidence) string {
+	sorted := slices.Clone(evidence)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].EvidenceID < sorted[j].EvidenceID })
+	h := sha256.New()
+	for _, item := range sorted {
+		fmt.Fprintf(h, "%s:%s\n", item.EvidenceID, item.EvidenceHash)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// RegisterClaimReference records that an insurer's claim rests on an incident and its evidence.
+// evidenceIDsJSON lists the evidence the claim cites; an empty list cites all of the incident's
+// evidence. The evidence hashes are copied from the ledger, so the insurer can later check nothing
+// has changed since.
+func (s *SIHChaincode) RegisterClaimReference(ctx contractapi.TransactionContextInterface, claimRefID, incidentID, insurerID, claimNumberHash, evidenceIDsJSON, actor string) error {
+	if claimRefID == "" || insurerID == "" || actor == "" {
+		return fmt.Errorf("claim reference %q must be complete", claimRefID)
+	}
+	if len(claimNumberHash) != 64 || strings.Trim(claimNumberHash, "0123456789abcdef") != "" {
+		return fmt.Errorf("invalid claim number hash %q", claimNumberHash)
+	}
+	var evidenceIDs []string
+	if evidenceIDsJSON != "" {
+		if err := json.Unmarshal([]byte(evidenceIDsJSON), &evidenceIDs); err != nil || len(evidenceIDs) > maxClaimEvidence {
+			return fmt.Errorf("invalid evidence IDs: at most %d may be listed", maxClaimEvidence)
+		}
+	}
+	existing, err := s.readState(ctx, "claim_reference", claimRefID)
+	if err == nil && existing != nil {
+		return fmt.Errorf("the claim reference %s already exists", claimRefID)
+	}
+
+	incident, err := s.ReadIncident(ctx, incidentID)
+	if err != nil {
+		return err
+	}
+	if incident.DocType != "incident" {
+		return fmt.Errorf("%s is not an incident", incidentID)
+	}
+	if incident.MergedInto != "" {
+		return fmt.Errorf("the incident %s was merged into %s", incidentID, incident.MergedInto)
+	}
+	evidence := []ClaimEvidence{}
+	if len(evidenceIDs) == 0 {
+		all, err := s.GetEvidenceByIncident(ctx, incidentID)
+		if err != nil {
+			return err
+		}
+		if len(all) > maxClaimEvidence {
+			return fmt.Errorf("the incident %s has more than %d evidence items; list the ones the claim cites", incidentID, maxClaimEvidence)
+		}
+		for _, item := range all {
+			evidence = append(evidence, ClaimEvidence{EvidenceID: item.EvidenceID, EvidenceHash: item.EvidenceHash})
+		}
+	}
+	for _, evidenceID := range evidenceIDs {
+		item, err := s.ReadEvidence(ctx, evidenceID)
+		if err != nil || item.IncidentID != incidentID {
+			return fmt.Errorf("the evidence %s does not belong to the incident %s", evidenceID, incidentID)
+		}
+		if slices.ContainsFunc(evidence, func(e ClaimEvidence) bool { return e.EvidenceID == evidenceID }) {
+			return fmt.Errorf("the evidence %s is listed twice", evidenceID)
+		}
+		evidence = append(evidence, ClaimEvidence{EvidenceID: evidenceID, EvidenceHash: item.EvidenceHash})
+	}
+
+	registeredAt, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	claim := ClaimReferenceDocument{
+		DocType:             "claim_reference",
+		ClaimRefID:          claimRefID,
+		IncidentID:          incidentID,
+		DigitalID:           incident.SubjectID,
+		InsurerID:           insurerID,
+		ClaimNumberHash:     claimNumberHash,
+		IncidentSummaryHash: incident.IncidentSummaryHash,
+		Evidence:            evidence,
+		EvidenceDigest:      claimEvidenceDigest(evidence),
+		RegisteredBy:        actor,
+		RegisteredAt:        registeredAt,
+		OwnerOrg:            submitterOrg(ctx),
+		TxID:                ctx.GetStub().GetTxID(),
+	}
+	claimJSON, err := json.Marshal(claim)
+	if err != nil {
+		return err
+	}
+	if err := putState(ctx, "claim_reference", claimRefID, claimJSON); err != nil {
+		return err
+	}
+	ctx.GetStub().SetEvent("RegisterClaimReference", claimJSON)
+	s.createAuditLog(ctx, actor, "REGISTER_CLAIM_REFERENCE", incidentID)
+	return nil
+}
+
+// ReadClaimReference returns the claim reference with the given ID
+func (s *SIHChaincode) ReadClaimReference(ctx contractapi.TransactionContextInterface, claimRefID string) (*ClaimReferenceDocument, error) {
+	claimJSON, err := s.readState(ctx, "claim_reference", claimRefID)
+	if err != nil {
+		return nil, err
+	}
+
+	var claim ClaimReferenceDocument
+	if err := json.Unmarshal(claimJSON, &claim); err != nil {
+		return nil, err
+	}
+	if claim.DocType != "claim_reference" {
+		return nil, fmt.Errorf("%s is not a claim reference", claimRefID)
+	}
+	return &claim, nil
+}
+
+// GetClaimReferencesByIncident returns the claim references registered against an incident
+func (s *SIHChaincode) GetClaimReferencesByIncident(ctx contractapi.TransactionContextInterface, incidentID string) ([]*ClaimReferenceDocument, error) {
+	claims := []*ClaimReferenceDocument{}
+	err := forEachQueryResult(ctx, map[string]interface{}{"doc_type": "claim_reference", "incident_id": incidentID}, func(value []byte) error {
+		var claim ClaimReferenceDocument
+		if err := json.Unmarshal(value, &claim); err != nil {
+			return err
+		}
+		claims = append(claims, &claim)
+		return nil
+	})
+	return claims, err
+}
+
+// ========== LOST AND FOUND OPERATIONS ==========
+
+const (
+	lostFoundLost     = "lost"
+	lostFoundFound    = "found"
+	lostFoundOpen     = "open"
+	lostFoundReturned = "returned"
+	lostFoundClosed   = "closed"
+	lostFoundRejected = "rejected"
+	maxLostFoundPhoto = 10
+)
+
+// ReportLostFoundItem records an item reported lost or handed in as found. A reporter DID, when
+// given, must be on the ledger.
+func (s *SIHChaincode) ReportLostFoundItem(ctx contractapi.TransactionContextInterface, itemID, kind, category, description, color, brand, locationName, geohash, occurredAt, reporterDID, actor string) error {
+	if kind != lostFoundLost && kind != lostFoundFound {
+		return fmt.Errorf("invalid item kind %q", kind)
+	}
+	if itemID == "" || category == "" || description == "" || locationName == "" || actor == "" {
+		return fmt.Errorf("item %q must be complete", itemID)
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	occurred, err := time.Parse(time.RFC3339, occurredAt)
+	if err != nil || occurred.After(now.Add(5*time.Minute)) {
+		return fmt.Errorf("invalid occurrence time %q: must not be in the future", occurredAt)
+	}
+	if reporterDID != "" {
+		if _, err := s.ReadDID(ctx, reporterDID); err != nil {
+			return err
+		}
+	}
+	existing, err := s.readState(ctx, "lost_found_item", itemID)
+	if err == nil && existing != nil {
+		return fmt.Errorf("the item %s already exists", itemID)
+	}
+
+	reportedAt := now.Format(time.RFC3339)
+	item := LostFoundItemDocument{
+		DocType:      "lost_found_item",
+		ItemID:       itemID,
+		Kind:         kind,
+		Category:     category,
+		Description:  description,
+		Color:        color,
+		Brand:        brand,
+		LocationName: locationName,
+		Geohash:      geohash,
+		OccurredAt:   occurredAt,
+		ReporterDID:  reporterDID,
+		Photos:       []LostFoundPhoto{},
+		Status:       lostFoundOpen,
+		ReportedBy:   actor,
+		ReportedAt:   reportedAt,
+		UpdatedBy:    actor,
+		UpdatedAt:    reportedAt,
+		OwnerOrg:     submitterOrg(ctx),
+		TxID:         ctx.GetStub().GetTxID(),
+	}
+	return s.putLostFoundItem(ctx, item, "ReportLostFoundItem", actor, "REPORT_LOST_FOUND_ITEM")
+}
+
+// AddLostFoundPhoto anchors the hash of a photo of an open item. Only the reporting organization
+// may add photos.
+func (s *SIHChaincode) AddLostFoundPhoto(ctx contractapi.TransactionContextInterface, itemID, photoHash, mediaType, actor string) error {
+	if len(photoHash) != 64 || strings.Trim(photoHash, "0123456789abcdef") != "" {
+		return fmt.Errorf("invalid photo hash %q", photoHash)
+	}
+	if !strings.HasPrefix(mediaType, "image/") || actor == "" {
+		return fmt.Errorf("invalid photo media type %q", mediaType)
+	}
+	item, err := s.ReadLostFoundItem(ctx, itemID)
+	if err != nil {
+		return err
+	}
+	if item.OwnerOrg != submitterOrg(ctx) {
+		return fmt.Errorf("access denied: only %s can add photos of the item %s", item.OwnerOrg, itemID)
+	}
+	if item.Status != lostFoundOpen {
+		return fmt.Errorf("the item %s is %s; it must be open", itemID, item.Status)
+	}
+	if slices.ContainsFunc(item.Photos, func(p LostFoundPhoto) bool { return p.PhotoHash == photoHash }) {
+		return fmt.Errorf("the photo %s of item %s already exists", photoHash, itemID)
+	}
+	if len(item.Photos) >= maxLostFoundPhoto {
+		return fmt.Errorf("the item %s must have at most %d photos", itemID, maxLostFoundPhoto)
+	}
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	item.Photos = append(item.Photos, LostFoundPhoto{PhotoHash: photoHash, MediaType: mediaType, AddedBy: actor, AddedAt: now})
+	item.UpdatedBy, item.UpdatedAt = actor, now
+	item.TxID = ctx.GetStub().GetTxID()
+	return s.putLostFoundItem(ctx, *item, "AddLostFoundPhoto", actor, "ADD_LOST_FOUND_PHOTO")
+}
+
+// CloseLostFoundItem withdraws an open item without a match, such as a lost item the tourist found
+// themselves or a found item disposed of. Only the reporting organization may.
+func (s *SIHChaincode) CloseLostFoundItem(ctx contractapi.TransactionContextInterface, itemID, reason, actor string) error {
+	item, err := s.ReadLostFoundItem(ctx, itemID)
+	if err != nil {
+		return err
+	}
+	if item.OwnerOrg != submitterOrg(ctx) {
+		return fmt.Errorf("access denied: only %s can close the item %s", item.OwnerOrg, itemID)
+	}
+	if item.Status != lostFoundOpen {
+		return fmt.Errorf("the item %s is %s; it must be open", itemID, item.Status)
+	}
+	if reason == "" || actor == "" {
+		return fmt.Errorf("closing the item %s needs a reason", itemID)
+	}
+	if item.UpdatedAt, err = txTimestamp(ctx); err != nil {
+		return err
+	}
+	item.Status, item.ClosedReason = lostFoundClosed, reason
+	item.UpdatedBy = actor
+	item.TxID = ctx.GetStub().GetTxID()
+	return s.putLostFoundItem(ctx, *item, "CloseLostFoundItem", actor, "CLOSE_LOST_FOUND_ITEM")
+}
+
+// ResolveLostFoundMatch records whether a lost and a found item are the same. Both must be open
+// and of the same category, and the submitter must have reported one of them. A returned match
+// closes both; a pair may be resolved only once.
+func (s *SIHChaincode) ResolveLostFoundMatch(ctx contractapi.TransactionContextInterface, matchID, lostItemID, foundItemID, resolution, note, actor string) error {
+	if resolution != lostFoundReturned && resolution != lostFoundRejected {
+		return fmt.Errorf("invalid match resolution %q", resolution)
+	}
+	if matchID == "" || actor == "" {
+		return fmt.Errorf("match %q must be complete", matchID)
+	}
+	existing, err := s.readState(ctx, "lost_found_match", matchID)
+	if err == nil && existing != nil {
+		return fmt.Errorf("the match %s already exists", matchID)
+	}
+	lost, err := s.ReadLostFoundItem(ctx, lostItemID)
+	if err != nil {
+		return err
+	}
+	found, err := s.ReadLostFoundItem(ctx, foundItemID)
+	if err != nil {
+		return err
+	}
+	if lost.Kind != lostFoundLost || found.Kind != lostFoundFound {
+		return fmt.Errorf("the match %s must pair a lost item with a found item", matchID)
+	}
+	if lost.Category != found.Category {
+		return fmt.Errorf("the items %s and %s are of different categories", lostItemID, foundItemID)
+	}
+	for _, item := range []*LostFoundItemDocument{lost, found} {
+		if item.Status != lostFoundOpen {
+			return fmt.Errorf("the item %s is %s; it must be open", item.ItemID, item.Status)
+		}
+	}
+	org := submitterOrg(ctx)
+	if lost.OwnerOrg != org && found.OwnerOrg != org {
+		return fmt.Errorf("access denied: only %s or %s can resolve a match of items %s and %s", lost.OwnerOrg, found.OwnerOrg, lostItemID, foundItemID)
+	}
+	previous, err := s.GetLostFoundMatchesByItem(ctx, lostItemID)
+	if err != nil {
+		return err
+	}
+	for _, m := range previous {
+		if m.FoundItemID == foundItemID {
+			return fmt.Errorf("the items %s and %s were already resolved as %s by %s", lostItemID, foundItemID, m.Resolution, m.MatchID)
+		}
+	}
+
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	match := LostFoundMatchDocument{
+		DocType:     "lost_found_match",
+		MatchID:     matchID,
+		LostItemID:  lostItemID,
+		FoundItemID: foundItemID,
+		Resolution:  resolution,
+		Note:        note,
+		ResolvedBy:  actor,
+		ResolvedAt:  now,
+		OwnerOrg:    org,
+		TxID:        ctx.GetStub().GetTxID(),
+	}
+	matchJSON, err := json.Marshal(match)
+	if err != nil {
+		return err
+	}
+	if err := putState(ctx, "lost_found_match", matchID, matchJSON); err != nil {
+		return err
+	}
+	if resolution == lostFoundReturned {
+		for _, item := range []*LostFoundItemDocument{lost, found} {
+			item.Status, item.MatchID = lostFoundReturned, matchID
+			item.UpdatedBy, item.UpdatedAt = actor, now
+			item.TxID = match.TxID
+			itemJSON, err := json.Marshal(item)
+			if err != nil {
+				return err
+			}
+			if err := putState(ctx, "lost_found_item", item.ItemID, itemJSON); err != nil {
+				return err
+			}
+		}
+	}
+	ctx.GetStub().SetEvent("ResolveLostFoundMatch", matchJSON)
+	s.createAuditLog(ctx, actor, "RESOLVE_LOST_FOUND_MATCH", lostItemID)
+	s.createAuditLog(ctx, actor, "RESOLVE_LOST_FOUND_MATCH", foundItemID)
+	return nil
+}
+
+func (s *SIHChaincode) putLostFoundItem(ctx contractapi.TransactionContextInterface, item LostFoundItemDocument, eventName, actor, action string) error {
+	itemJSON, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	if err := putState(ctx, "lost_found_item", item.ItemID, itemJSON); err != nil {
+		return err
+	}
+	ctx.GetStub().SetEvent(eventName, itemJSON)
+	s.createAuditLog(ctx, actor, action, item.ItemID)
+	return nil
+}
+
+// ReadLostFoundItem returns the lost or found item with the given ID
+func (s *SIHChaincode) ReadLostFoundItem(ctx contractapi.TransactionContextInterface, itemID string) (*LostFoundItemDocument, error) {
+	itemJSON, err := s.readState(ctx, "lost_found_item", itemID)
+	if err != nil {
+		return nil, err
+	}
+
+	var item LostFoundItemDocument
+	if err := json.Unmarshal(itemJSON, &item); err != nil {
+		return nil, err
+	}
+	if item.DocType != "lost_found_item" {
+		return nil, fmt.Errorf("%s is not a lost or found item", itemID)
+	}
+	return &item, nil
+}
+
+// ReadLostFoundMatch returns the match resolution with the given ID
+func (s *SIHChaincode) ReadLostFoundMatch(ctx contractapi.TransactionContextInterface, matchID string) (*LostFoundMatchDocument, error) {
+	matchJSON, err := s.readState(ctx, "lost_found_match", matchID)
+	if err != nil {
+		return nil, err
+	}
+
+	var match LostFoundMatchDocument
+	if err := json.Unmarshal(matchJSON, &match); err != nil {
+		return nil, err
+	}
+	if match.DocType != "lost_found_match" {
+		return nil, fmt.Errorf("%s is not a lost and found match", matchID)
+	}
+	return &match, nil
+}
+
+// QueryLostFoundItems returns the items of a kind, category and status; empty arguments match any
+func (s *SIHChaincode) QueryLostFoundItems(ctx contractapi.TransactionContextInterface, kind, category, status string) ([]*LostFoundItemDocument, error) {
+	selector := map[string]interface{}{"doc_type": "lost_found_item"}
+	for field, value := range map[string]string{"kind": kind, "category": category, "status": status} {
+		if value != "" {
+			selector[field] = value
+		}
+	}
+	items := []*LostFoundItemDocument{}
+	err := forEachQueryResult(ctx, selector, func(value []byte) error {
+		var item LostFoundItemDocument
+		if err := json.Unmarshal(value, &item); err != nil {
+			return err
+		}
+		items = append(items, &item)
+		return nil
+	})
+	return items, err
+}
+
+// GetLostFoundMatchesByItem returns the match resolutions involving an item
+func (s *SIHChaincode) GetLostFoundMatchesByItem(ctx contractapi.TransactionContextInterface, itemID string) ([]*LostFoundMatchDocument, error) {
+	matches := []*LostFoundMatchDocument{}
+	selector := map[string]interface{}{"doc_type": "lost_found_match", "$or": []interface{}{
+		map[string]interface{}{"lost_item_id": itemID},
+		map[string]interface{}{"found_item_id": itemID},
+	}}
+	err := forEachQueryResult(ctx, selector, func(value []byte) error {
+		var match LostFoundMatchDocument
+		if err := json.Unmarshal(value, &match); err != nil {
+			return err
+		}
+		matches = append(matches, &match)
+		return nil
+	})
+	return matches, err
+}
+
+// ========== MISSING PERSON CASE OPERATIONS ==========
+
+// Missing person case stages. A case opens once, may be revised and sighted any number of times,
+// and ends when the tourist is found or the search is called off.
+const caseStageClosed = "closed"
+
+var caseStages = map[string]bool{"opened": true, "area_revised": true, "sighted": true, "found": true, caseStageClosed: true}
+
+// AnchorCaseStage records the digest of a missing person case at a stage. The anchors of a case are
+// numbered in order, and none may follow the one that closes it.
+func (s *SIHChaincode) AnchorCaseStage(ctx contractapi.TransactionContextInterface, anchorID, incidentID string, sequence int, stage, digest, actor string) error {
+	if !caseStages[stage] {
+		return fmt.Errorf("invalid case stage %q", stage)
+	}
+	if anchorID == "" || digest == "" {
+		return fmt.Errorf("case anchor %q must be complete", anchorID)
+	}
+	incident, err := s.ReadIncident(ctx, incidentID)
+	if err != nil {
+		return err
+	}
+	if incident.Category != "missing_person" {
+		return fmt.Errorf("the incident %s is not a missing person report", incidentID)
+	}
+	anchors, err := s.GetCaseAnchors(ctx, incidentID)
+	if err != nil {
+		return err
+	}
+	for _, anchor := range anchors {
+		if anchor.Stage == caseStageClosed {
+			return fmt.Errorf("the case %s is closed", incidentID)
+		}
+	}
+	if sequence != len(anchors)+1 {
+		return fmt.Errorf("the next anchor of case %s is %d, not %d", incidentID, len(anchors)+1, sequence)
+	}
+	if (sequence == 1) != (stage == "opened") {
+		return fmt.Errorf("a case must open with its first anchor only")
+	}
+	existing, err := s.readState(ctx, "case_anchor", anchorID)
+	if err == nil && existing != nil {
+		return fmt.Errorf("the case anchor %s already exists", anchorID)
+	}
+
+	anchoredAt, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	anchor := CaseAnchorDocument{
+		DocType:    "case_anchor",
+		AnchorID:   anchorID,
+		IncidentID: incidentID,
+		Sequence:   sequence,
+		Stage:      stage,
+		Digest:     digest,
+		AnchoredBy: actor,
+		AnchoredAt: anchoredAt,
+		OwnerOrg:   submitterOrg(ctx),
+		TxID:       ctx.GetStub().GetTxID(),
+	}
+	anchorJSON, err := json.Marshal(anchor)
+	if err != nil {
+		return err
+	}
+	if err := putState(ctx, "case_anchor", anchorID, anchorJSON); err != nil {
+		return err
+	}
+	ctx.GetStub().SetEvent("AnchorCaseStage", anchorJSON)
+	s.createAuditLog(ctx, actor, "ANCHOR_CASE_STAGE", anchorID)
+	return nil
+}
+
+// GetCaseAnchors returns the anchors of a missing person case in sequence
+func (s *SIHChaincode) GetCaseAnchors(ctx contractapi.TransactionContextInterface, incidentID string) ([]*CaseAnchorDocument, error) {
+	anchors := []*CaseAnchorDocument{}
+	err := forEachQueryResult(ctx, map[string]interface{}{"doc_type": "case_anchor", "incident_id": incidentID}, func(value []byte) error {
+		var anchor CaseAnchorDocument
+		if err := json.Unmarshal(value, &anchor); err != nil {
+			return err
+		}
+		anchors = append(anchors, &anchor)
+		return nil
+	})
+	sort.Slice(anchors, func(i, j int) bool { return anchors[i].Sequence < anchors[j].Sequence })
+	return anchors, err
+}
+
+// ========== DEVICE REGISTRY OPERATIONS ==========
+
+const (
+	deviceBind   = "bind"
+	deviceUnbind = "unbind"
+)
+
+// RegisterDevice adds a safety band to the registry, unbound. attestationKey may be empty for bands
+// that cannot sign their telemetry.
+func (s *SIHChaincode) RegisterDevice(ctx contractapi.TransactionContextInterface, deviceID, deviceType, attestationKey, actor string) error {
+	if deviceID == "" || deviceType == "" {
+		return fmt.Errorf("device %q must be complete", deviceID)
+	}
+	if attestationKey != "" {
+		if key, err := base64.StdEncoding.DecodeString(attestationKey); err != nil || len(key) != 32 {
+			return fmt.Errorf("the attestation key of device %s must be a base64 Ed25519 public key", deviceID)
+		}
+	}
+	existing, err := s.readState(ctx, "device", deviceID)
+	if err == nil && existing != nil {
+		return fmt.Errorf("the device %s already exists", deviceID)
+	}
+
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	device := DeviceDocument{
+		DocType:        "device",
+		DeviceID:       deviceID,
+		Type:           deviceType,
+		AttestationKey: attestationKey,
+		RegisteredBy:   actor,
+		RegisteredAt:   now,
+		UpdatedAt:      now,
+		OwnerOrg:       submitterOrg(ctx),
+		TxID:           ctx.GetStub().GetTxID(),
+	}
+	return s.putDevice(ctx, device, "RegisterDevice", actor, "REGISTER_DEVICE")
+}
+
+// BindDevice pairs an unbound band with a tourist
+func (s *SIHChaincode) BindDevice(ctx contractapi.TransactionContextInterface, deviceID, digitalID, actor string) error {
+	device, err := s.ReadDevice(ctx, deviceID)
+	if err != nil {
+		return err
+	}
+	if device.DigitalID != "" {
+		return fmt.Errorf("the device %s is bound to %s and must be unbound first", deviceID, device.DigitalID)
+	}
+	if _, err := s.ReadDID(ctx, digitalID); err != nil {
+		return err
+	}
+	return s.putBinding(ctx, device, deviceBind, digitalID, actor)
+}
+
+// UnbindDevice releases a band from the tourist wearing it
+func (s *SIHChaincode) UnbindDevice(ctx contractapi.TransactionContextInterface, deviceID, actor string) error {
+	device, err := s.ReadDevice(ctx, deviceID)
+	if err != nil {
+		return err
+	}
+	if device.DigitalID == "" {
+		return fmt.Errorf("the device %s is not bound", deviceID)
+	}
+	return s.putBinding(ctx, device, deviceUnbind, device.DigitalID, actor)
+}
+
+// putBinding records the next binding of a device and updates the device to match
+func (s *SIHChaincode) putBinding(ctx contractapi.TransactionContextInterface, device *DeviceDocument, action, digitalID, actor string) error {
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	binding := DeviceBindingDocument{
+		DocType:   "device_binding",
+		BindingID: fmt.Sprintf("BIND:%s:%d", device.DeviceID, device.Bindings+1),
+		DeviceID:  device.DeviceID,
+		Sequence:  device.Bindings + 1,
+		Action:    action,
+		DigitalID: digitalID,
+		Actor:     actor,
+		At:        now,
+		OwnerOrg:  submitterOrg(ctx),
+		TxID:      ctx.GetStub().GetTxID(),
+	}
+	bindingJSON, err := json.Marshal(binding)
+	if err != nil {
+		return err
+	}
+	if err := putState(ctx, "device_binding", binding.BindingID, bindingJSON); err != nil {
+		return err
+	}
+
+	device.Bindings, device.UpdatedAt, device.TxID = binding.Sequence, now, binding.TxID
+	device.DigitalID, device.BindingID = "", ""
+	eventName, auditAction := "UnbindDevice", "UNBIND_DEVICE"
+	if action == deviceBind {
+		device.DigitalID, device.BindingID = digitalID, binding.BindingID
+		eventName, auditAction = "BindDevice", "BIND_DEVICE"
+	}
+	return s.putDevice(ctx, *device, eventName, actor, auditAction)
+}
+
+func (s *SIHChaincode) putDevice(ctx contractapi.TransactionContextInterface, device DeviceDocument, eventName, actor, action string) error {
+	deviceJSON, err := json.Marshal(device)
+	if err != nil {
+		return err
+	}
+	if err := putState(ctx, "device", device.DeviceID, deviceJSON); err != nil {
+		return err
+	}
+	ctx.GetStub().SetEvent(eventName, deviceJSON)
+	s.createAuditLog(ctx, actor, action, device.DeviceID)
+	return nil
+}
+
+// ReadDevice returns the device with the given ID
+func (s *SIHChaincode) ReadDevice(ctx contractapi.TransactionContextInterface, deviceID string) (*DeviceDocument, error) {
+	deviceJSON, err := s.readState(ctx, "device", deviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	var device DeviceDocument
+	if err := json.Unmarshal(deviceJSON, &device); err != nil {
+		return nil, err
+	}
+	if device.DocType != "device" {
+		return nil, fmt.Errorf("the device %s does not exist", deviceID)
+	}
+	return &device, nil
+}
+
+// GetDeviceBindings returns a device's bindings in sequence
+func (s *SIHChaincode) GetDeviceBindings(ctx contractapi.TransactionContextInterface, deviceID string) ([]*DeviceBindingDocument, error) {
+	bindings := []*DeviceBindingDocument{}
+	err := forEachQueryResult(ctx, map[string]interface{}{"doc_type": "device_binding", "device_id": deviceID}, func(value []byte) error {
+		var binding DeviceBindingDocument
+		if err := json.Unmarshal(value, &binding); err != nil {
+			return err
+		}
+		bindings = append(bindings, &binding)
+		return nil
+	})
+	sort.Slice(bindings, func(i, j int) bool { return bindings[i].Sequence < bindings[j].Sequence })
+	return bindings, err
+}
+
+// GetAllDevices returns every registered device
+func (s *SIHChaincode) GetAllDevices(ctx contractapi.TransactionContextInterface) ([]*DeviceDocument, error) {
+	devices := []*DeviceDocument{}
+	err := forEachQueryResult(ctx, map[string]interface{}{"doc_type": "device"}, func(value []byte) error {
+		var device DeviceDocument
+		if err := json.Unmarshal(value, &device); err != nil {
+			return err
+		}
+		devices = append(devices, &device)
+		return nil
+	})
+	return devices, err
+}
+
+// ========== WEATHER ADVISORY OPERATIONS ==========
+
+// AnchorAdvisory records the digest of an ingested advisory and the zones it affected, so what the
+// gateway acted on can be checked against the issuing agency's feed later. zoneIDsJSON is a JSON
+// array of zone IDs, which may be empty.
+func (s *SIHChaincode) AnchorAdvisory(ctx contractapi.TransactionContextInterface, anchorID, advisoryID, source, digest, severity, zoneIDsJSON, issuedAt, expiresAt, actor string) error {
+	if anchorID == "" || advisoryID == "" || source == "" || digest == "" {
+		return fmt.Errorf("advisory anchor %q must be complete", anchorID)
+	}
+	if _, err := time.Parse(time.RFC3339, issuedAt); err != nil {
+		return fmt.Errorf("invalid issue time %q", issuedAt)
+	}
+	if _, err := time.Parse(time.RFC3339, expiresAt); err != nil {
+		return fmt.Errorf("invalid expiry time %q", expiresAt)
+	}
+	zoneIDs := []string{}
+	if err := json.Unmarshal([]byte(zoneIDsJSON), &zoneIDs); err != nil {
+		return fmt.Errorf("invalid zone IDs: %v", err)
+	}
+	existing, err := s.readState(ctx, "advisory_anchor", anchorID)
+	if err == nil && existing != nil {
+		return fmt.Errorf("the advisory anchor %s already exists", anchorID)
+	}
+
+	anchoredAt, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	anchor := AdvisoryAnchorDocument{
+		DocType:    "advisory_anchor",
+		AnchorID:   anchorID,
+		AdvisoryID: advisoryID,
+		Source:     source,
+		Digest:     digest,
+		Severity:   severity,
+		ZoneIDs:    zoneIDs,
+		IssuedAt:   issuedAt,
+		ExpiresAt:  expiresAt,
+		AnchoredBy: actor,
+		AnchoredAt: anchoredAt,
+		TxID:       ctx.GetStub().GetTxID(),
+	}
+	anchorJSON, err := json.Marshal(anchor)
+	if err != nil {
+		return err
+	}
+	if err := putState(ctx, "advisory_anchor", anchorID, anchorJSON); err != nil {
+		return err
+	}
+	ctx.GetStub().SetEvent("AnchorAdvisory", anchorJSON)
+	s.createAuditLog(ctx, actor, "ANCHOR_ADVISORY", anchorID)
+	return nil
+}
+
+// ReadAdvisoryAnchor returns the advisory anchor with the given ID
+func (s *SIHChaincode) ReadAdvisoryAnchor(ctx contractapi.TransactionContextInterface, anchorID string) (*AdvisoryAnchorDocument, error) {
+	anchorJSON, err := s.readState(ctx, "advisory_anchor", anchorID)
+	if err != nil {
+		return nil, err
+	}
+
+	var anchor AdvisoryAnchorDocument
+	if err := json.Unmarshal(anchorJSON, &anchor); err != nil {
+		return nil, err
+	}
+	if anchor.DocType != "advisory_anchor" {
+		return nil, fmt.Errorf("%s is not an advisory anchor", anchorID)
+	}
+	return &anchor, nil
+}
+
+// GetAdvisoryAnchors returns every version of an advisory that was anchored
+func (s *SIHChaincode) GetAdvisoryAnchors(ctx contractapi.TransactionContextInterface, advisoryID string) ([]*AdvisoryAnchorDocument, error) {
+	anchors := []*AdvisoryAnchorDocument{}
+	err := forEachQueryResult(ctx, map[string]interface{}{"doc_type": "advisory_anchor", "advisory_id": advisoryID}, func(value []byte) error {
+		var anchor AdvisoryAnchorDocument
+		if err := json.Unmarshal(value, &anchor); err != nil {
+			return err
+		}
+		anchors = append(anchors, &anchor)
+		return nil
+	})
+	return anchors, err
+}
+
+// ========== PROXIMITY BROADCAST OPERATIONS ==========
+
+// RecordBroadcast records a proximity broadcast before it is delivered. An incident must be
+// acknowledged and of high or critical severity, and the broadcast belongs to its organization.
+// Tourists in the area who opted out count towards candidates but not recipients.
+func (s *SIHChaincode) RecordBroadcast(ctx contractapi.TransactionContextInterface, broadcastID, sourceType, sourceID, severity, areaDigest, zoneID, pingsSince, messageDigest, recipientsDigest string, candidates, optedOut, recipients int, actor string) error {
+	for _, digest := range []string{areaDigest, messageDigest, recipientsDigest} {
+		if len(digest) != 64 || strings.Trim(digest, "0123456789abcdef") != "" {
+			return fmt.Errorf("invalid hash %q", digest)
+		}
+	}
+	if broadcastID == "" || sourceID == "" || actor == "" {
+		return fmt.Errorf("broadcast %q must be complete", broadcastID)
+	}
+	if candidates < 0 || optedOut < 0 || recipients < 0 || optedOut+recipients > candidates {
+		return fmt.Errorf("broadcast %s has inconsistent counts", broadcastID)
+	}
+	if _, err := time.Parse(time.RFC3339, pingsSince); err != nil {
+		return fmt.Errorf("invalid ping cutoff %q", pingsSince)
+	}
+	existing, err := s.readState(ctx, "broadcast", broadcastID)
+	if err == nil && existing != nil {
+		return fmt.Errorf("the broadcast %s already exists", broadcastID)
+	}
+
+	ownerOrg := ""
+	switch sourceType {
+	case "incident":
+		incident, err := s.ReadIncident(ctx, sourceID)
+		if err != nil {
+			return err
+		}
+		if incident.Status != incidentStatusAcknowledged {
+			return fmt.Errorf("the incident %s must be acknowledged to be broadcast", sourceID)
+		}
+		if incident.Severity != "high" && incident.Severity != "critical" {
+			return fmt.Errorf("the incident %s must be of high or critical severity to be broadcast", sourceID)
+		}
+		severity, ownerOrg = incident.Severity, incident.OwnerOrg
+	case "advisory":
+		if severity == "" {
+			return fmt.Errorf("broadcast %s must have the advisory's severity", broadcastID)
+		}
+	default:
+		return fmt.Errorf("invalid broadcast source type %q", sourceType)
+	}
+
+	decidedAt, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	broadcast := BroadcastDocument{
+		DocType:          "broadcast",
+		BroadcastID:      broadcastID,
+		SourceType:       sourceType,
+		SourceID:         sourceID,
+		Severity:         severity,
+		AreaDigest:       areaDigest,
+		ZoneID:           zoneID,
+		PingsSince:       pingsSince,
+		MessageDigest:    messageDigest,
+		RecipientsDigest: recipientsDigest,
+		Candidates:       candidates,
+		OptedOut:         optedOut,
+		Recipients:       recipients,
+		DecidedBy:        actor,
+		DecidedAt:        decidedAt,
+		OwnerOrg:         ownerOrg,
+		TxID:             ctx.GetStub().GetTxID(),
+	}
+	broadcastJSON, err := json.Marshal(broadcast)
+	if err != nil {
+		return err
+	}
+	if err := putState(ctx, "broadcast", broadcastID, broadcastJSON); err != nil {
+		return err
+	}
+	ctx.GetStub().SetEvent("RecordBroadcast", broadcastJSON)
+	s.createAuditLog(ctx, actor, "RECORD_BROADCAST", broadcastID)
+	return nil
+}
+
+// ReadBroadcast returns the broadcast with the given ID
+func (s *SIHChaincode) ReadBroadcast(ctx contractapi.TransactionContextInterface, broadcastID string) (*BroadcastDocument, error) {
+	broadcastJSON, err := s.readState(ctx, "broadcast", broadcastID)
+	if err != nil {
+		return nil, err
+	}
+
+	var broadcast BroadcastDocument
+	if err := json.Unmarshal(broadcastJSON, &broadcast); err != nil {
+		return nil, err
+	}
+	if broadcast.DocType != "broadcast" {
+		return nil, fmt.Errorf("%s is not a broadcast", broadcastID)
+	}
+	return &broadcast, nil
+}
+
+// GetBroadcasts returns the broadcasts recorded for an incident or advisory
+func (s *SIHChaincode) GetBroadcasts(ctx contractapi.TransactionContextInterface, sourceType, sourceID string) ([]*BroadcastDocument, error) {
+	broadcasts := []*BroadcastDocument{}
+	err := forEachQueryResult(ctx, map[string]interface{}{"doc_type": "broadcast", "source_type": sourceType, "source_id": sourceID}, func(value []byte) error {
+		var broadcast BroadcastDocument
+		if err := json.Unmarshal(value, &broadcast); err != nil {
+			return err
+		}
+		broadcasts = append(broadcasts, &broadcast)
+		return nil
+	})
+	return broadcasts, err
+}
+
+// ========== REPORT ANCHOR OPERATIONS ==========
+
+// AnchorReport records the digests of an operations report covering [periodStart, periodEnd)
+func (s *SIHChaincode) AnchorReport(ctx contractapi.TransactionContextInterface, reportID, period, periodStart, periodEnd, pdfHash, csvHash, actor string) error {
+	if !reportPeriods[period] {
+		return fmt.Errorf("invalid report period %q", period)
+	}
+	start, err := time.Parse(time.RFC3339, periodStart)
+	if err != nil {
+		return fmt.Errorf("invalid period start %q", periodStart)
+	}
+	end, err := time.Parse(time.RFC3339, periodEnd)
+	if err != nil || !end.After(start) {
+		return fmt.Errorf("invalid period end %q", periodEnd)
+	}
+	if reportID == "" || pdfHash == "" || csvHash == "" {
+		return fmt.Errorf("report anchor %q must be complete", reportID)
+	}
+	existing, err := s.readState(ctx, "report_anchor", reportID)
+	if err == nil && existing != nil {
+		return fmt.Errorf("the report %s already exists", reportID)
+	}
+
+	anchoredAt, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	anchor := ReportAnchorDocument{
+		DocType:     "report_anchor",
+		ReportID:    reportID,
+		Period:      period,
+		PeriodStart: periodStart,
+		PeriodEnd:   periodEnd,
+		PDFHash:     pdfHash,
+		CSVHash:     csvHash,
+		GeneratedBy: actor,
+		AnchoredAt:  anchoredAt,
+		TxID:        ctx.GetStub().GetTxID(),
+	}
+	anchorJSON, err := json.Marshal(anchor)
+	if err != nil {
+		return err
+	}
+	if err := putState(ctx, "report_anchor", reportID, anchorJSON); err != nil {
+		return err
+	}
+	ctx.GetStub().SetEvent("AnchorReport", anchorJSON)
+	s.createAuditLog(ctx, actor, "ANCHOR_REPORT", reportID)
+	return nil
+}
+
+// ReadReportAnchor returns the report anchor with the given ID
+func (s *SIHChaincode) ReadReportAnchor(ctx contractapi.TransactionContextInterface, reportID string) (*ReportAnchorDocument, error) {
+	anchorJSON, err := s.readState(ctx, "report_anchor", reportID)
+	if err != nil {
+		return nil, err
+	}
+	var anchor ReportAnchorDocument
+	if err := json.Unmarshal(anchorJSON, &anchor); err != nil {
+		return nil, err
+	}
+	if anchor.DocType != "report_anchor" {
+		return nil, fmt.Errorf("%s is not a report anchor", reportID)
+	}
+	return &anchor, nil
+}
+
+// GetReportAnchors returns every anchored report of a period, daily or weekly
+func (s *SIHChaincode) GetReportAnchors(ctx contractapi.TransactionContextInterface, period string) ([]*ReportAnchorDocument, error) {
+	anchors := []*ReportAnchorDocument{}
+	err := forEachQueryResult(ctx, map[string]interface{}{"doc_type": "report_anchor", "period": period}, func(value []byte) error {
+		var anchor ReportAnchorDocument
+		if err := json.Unmarshal(value, &anchor); err != nil {
+			return err
+		}
+		anchors = append(anchors, &anchor)
+		return nil
+	})
+	return anchors, err
+}
+
+// ========== SLA BREACH OPERATIONS ==========
+
+// RecordSLABreach records that an open incident has passed its acknowledgement or resolution target,
+// and adds an SLA_BREACH audit entry to the incident. The breach belongs to the incident's
+// organization whoever detects it.
+func (s *SIHChaincode) RecordSLABreach(ctx contractapi.TransactionContextInterface, breachID, incidentID, kind, district string, targetSeconds, elapsedSeconds int, detectedBy string) error {
+	if !slaBreachKinds[kind] {
+		return fmt.Errorf("invalid SLA breach kind %q", kind)
+	}
+	if breachID == "" || detectedBy == "" || targetSeconds <= 0 {
+		return fmt.Errorf("SLA breach %q must be complete", breachID)
+	}
+	if elapsedSeconds < targetSeconds {
+		return fmt.Errorf("elapsed time must be past the target of %d seconds", targetSeconds)
+	}
+	existing, err := s.readState(ctx, "sla_breach", breachID)
+	if err == nil && existing != nil {
+		return fmt.Errorf("the SLA breach %s already exists", breachID)
+	}
+	incident, err := s.ReadIncident(ctx, incidentID)
+	if err != nil {
+		return err
+	}
+	switch {
+	case incident.Status == "resolved" || incident.Status == "closed":
+		return fmt.Errorf("the incident %s is %s; it must be open", incidentID, incident.Status)
+	case kind == "acknowledge" && incident.AcknowledgedAt != "":
+		return fmt.Errorf("the incident %s was acknowledged at %s; it must be unacknowledged", incidentID, incident.AcknowledgedAt)
+	}
+
+	detectedAt, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	breach := SLABreachDocument{
+		DocType:        "sla_breach",
+		BreachID:       breachID,
+		IncidentID:     incidentID,
+		Kind:           kind,
+		Severity:       incident.Severity,
+		District:       district,
+		TargetSeconds:  targetSeconds,
+		ElapsedSeconds: elapsedSeconds,
+		DetectedBy:     detectedBy,
+		DetectedAt:     detectedAt,
+		OwnerOrg:       incident.OwnerOrg,
+		TxID:           ctx.GetStub().GetTxID(),
+	}
+	breachJSON, err := json.Marshal(breach)
+	if err != nil {
+		return err
+	}
+	if err := putState(ctx, "sla_breach", breachID, breachJSON); err != nil {
+		return err
+	}
+	ctx.GetStub().SetEvent("RecordSLABreach", breachJSON)
+	s.createAuditLog(ctx, detectedBy, "SLA_BREACH", incidentID)
+	return nil
+}
+
+// QuerySLABreaches returns the SLA breaches matching every non-empty filter
+func (s *SIHChaincode) QuerySLABreaches(ctx contractapi.TransactionContextInterface, incidentID, district, kind string) ([]*SLABreachDocument, error) {
+	selector := map[string]interface{}{"doc_type": "sla_breach"}
+	for field, value := range map[string]string{"incident_id": incidentID, "district": district, "kind": kind} {
+		if value != "" {
+			selector[field] = value
+		}
+	}
+	breaches := []*SLABreachDocument{}
+	err := forEachQueryResult(ctx, selector, func(value []byte) error {
+		var breach SLABreachDocument
+		if err := json.Unmarshal(value, &breach); err != nil {
+			return err
+		}
+		breaches = append(breaches, &breach)
+		return nil
+	})
+	return breaches, err
+}
+
+// ========== HELPLINE CALL OPERATIONS ==========
+
+// Helpline call outcomes: connected to a responder, not answered by any, or hung up before either
+var callOutcomes = map[string]bool{"connected": true, "unanswered": true, "abandoned": true}
+
+// AnchorCall records the metadata of a finished helpline call against the incident it opened. Only
+// a connected call names the unit that answered it.
+func (s *SIHChaincode) AnchorCall(ctx contractapi.TransactionContextInterface, callID, incidentID, provider, callerHash, unitID, outcome, startedAt, endedAt, recordingHash, evidenceID, actor string) error {
+	if !callOutcomes[outcome] {
+		return fmt.Errorf("invalid call outcome %q", outcome)
+	}
+	if callID == "" || provider == "" || callerHash == "" {
+		return fmt.Errorf("call %q must be complete", callID)
+	}
+	if (unitID != "") != (outcome == "connected") {
+		return fmt.Errorf("only a connected call names the unit that answered it")
+	}
+	if (recordingHash == "") != (evidenceID == "") {
+		return fmt.Errorf("a call recording must be recorded as evidence")
+	}
+	start, err := time.Parse(time.RFC3339, startedAt)
+	if err != nil {
+		return fmt.Errorf("invalid call start %q", startedAt)
+	}
+	end, err := time.Parse(time.RFC3339, endedAt)
+	if err != nil || end.Before(start) {
+		return fmt.Errorf("invalid call end %q", endedAt)
+	}
+	incident, err := s.ReadIncident(ctx, incidentID)
+	if err != nil {
+		return err
+	}
+	if evidenceID != "" {
+		evidence, err := s.ReadEvidence(ctx, evidenceID)
+		if err != nil {
+			return err
+		}
+		if evidence.IncidentID != incidentID || evidence.EvidenceHash != recordingHash {
+			return fmt.Errorf("the evidence %s is not the recording of call %s", evidenceID, callID)
+		}
+	}
+	existing, err := s.readState(ctx, "call", callID)
+	if err == nil && existing != nil {
+		return fmt.Errorf("the call %s already exists", callID)
+	}
+
+	anchoredAt, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	call := CallDocument{
+		DocType:         "call",
+		CallID:          callID,
+		Provider:        provider,
+		IncidentID:      incidentID,
+		CallerHash:      callerHash,
+		UnitID:          unitID,
+		Outcome:         outcome,
+		StartedAt:       startedAt,
+		EndedAt:         endedAt,
+		DurationSeconds: int(end.Sub(start).Seconds()),
+		RecordingHash:   recordingHash,
+		EvidenceID:      evidenceID,
+		AnchoredBy:      actor,
+		AnchoredAt:      anchoredAt,
+		OwnerOrg:        incident.OwnerOrg,
+		TxID:            ctx.GetStub().GetTxID(),
+	}
+	callJSON, err := json.Marshal(call)
+	if err != nil {
+		return err
+	}
+	if err := putState(ctx, "call", callID, callJSON); err != nil {
+		return err
+	}
+	ctx.GetStub().SetEvent("AnchorCall", callJSON)
+	s.createAuditLog(ctx, actor, "ANCHOR_CALL", callID)
+	return nil
+}
+
+// ReadCall returns the helpline call with the given ID
+func (s *SIHChaincode) ReadCall(ctx contractapi.TransactionContextInterface, callID string) (*CallDocument, error) {
+	callJSON, err := s.readState(ctx, "call", callID)
+	if err != nil {
+		return nil, err
+	}
+	var call CallDocument
+	if err := json.Unmarshal(callJSON, &call); err != nil {
+		return nil, err
+	}
+	if call.DocType != "call" {
+		return nil, fmt.Errorf("%s is not a call", callID)
+	}
+	return &call, nil
+}
+
+// GetCallsByIncident returns the helpline calls anchored against an incident, earliest first
+func (s *SIHChaincode) GetCallsByIncident(ctx contractapi.TransactionContextInterface, incidentID string) ([]*CallDocument, error) {
+	calls := []*CallDocument{}
+	err := forEachQueryResult(ctx, map[string]interface{}{"doc_type": "call", "incident_id": incidentID}, func(value []byte) error {
+		var call CallDocument
+		if err := json.Unmarshal(value, &call); err != nil {
+			return err
+		}
+		calls = append(calls, &call)
+		return nil
+	})
+	sort.Slice(calls, func(i, j int) bool { return calls[i].StartedAt < calls[j].StartedAt })
+	return calls, err
+}
+
+// ========== 112 ERSS EXCHANGE OPERATIONS ==========
+
+// ERSS exchange statuses for each direction: pushes are delivered or failed, intakes received
+var erssExchangeStatuses = map[string]map[string]bool{
+	"outbound": {"delivered": true, "failed": true},
+	"inbound":  {"received": true},
+}
+
+// RecordERSSExchange anchors an exchange with 112 ERSS against the SOS or incident it concerns.
+// Recording the same exchange again is a no-op, so a gateway retrying after an unclear commit does
+// not fail; only a failed push may be replaced, by its later outcome.
+func (s *SIHChaincode) RecordERSSExchange(ctx contractapi.TransactionContextInterface, exchangeID, direction, erssEventID, subjectType, subjectID, payloadHash, status string, attempts int, exchangedAt, actor string) error {
+	statuses, ok := erssExchangeStatuses[direction]
+	if !ok {
+		return fmt.Errorf("invalid ERSS exchange direction %q", direction)
+	}
+	if !statuses[status] {
+		return fmt.Errorf("invalid status %q for an %s ERSS exchange", status, direction)
+	}
+	if exchangeID == "" || erssEventID == "" || len(payloadHash) != 64 || attempts < 1 {
+		return fmt.Errorf("ERSS exchange %q must be complete", exchangeID)
+	}
+	if _, err := time.Parse(time.RFC3339, exchangedAt); err != nil {
+		return fmt.Errorf("invalid exchange time %q", exchangedAt)
+	}
+	var ownerOrg string
+	switch subjectType {
+	case "incident":
+		incident, err := s.ReadIncident(ctx, subjectID)
+		if err != nil {
+			return err
+		}
+		ownerOrg = incident.OwnerOrg
+	case "sos":
+		alert, err := s.ReadSOS(ctx, subjectID)
+		if err != nil {
+			return err
+		}
+		ownerOrg = alert.OwnerOrg
+	default:
+		return fmt.Errorf("invalid ERSS exchange subject type %q", subjectType)
+	}
+	existingJSON, err := getState(ctx, "erss_exchange", exchangeID)
+	if err != nil {
+		return err
+	}
+	if existingJSON != nil {
+		var existing ERSSExchangeDocument
+		if err := json.Unmarshal(existingJSON, &existing); err != nil {
+			return err
+		}
+		if existing.Status == status && existing.PayloadHash == payloadHash {
+			return nil
+		}
+		if existing.Status != "failed" {
+			return fmt.Errorf("the ERSS exchange %s is already recorded as %s", exchangeID, existing.Status)
+		}
+	}
+
+	exchange := ERSSExchangeDocument{
+		DocType:     "erss_exchange",
+		ExchangeID:  exchangeID,
+		Direction:   direction,
+		ERSSEventID: erssEventID,
+		SubjectType: subjectType,
+		SubjectID:   subjectID,
+		PayloadHash: payloadHash,
+		Status:      status,
+		Attempts:    attempts,
+		ExchangedAt: exchangedAt,
+		RecordedBy:  actor,
+		OwnerOrg:    ownerOrg,
+		TxID:        ctx.GetStub().GetTxID(),
+	}
+	exchangeJSON, err := json.Marshal(exchange)
+	if err != nil {
+		return err
+	}
+	if err := putState(ctx, "erss_exchange", exchangeID, exchangeJSON); err != nil {
+		return err
+	}
+	ctx.GetStub().SetEvent("RecordERSSExchange", exchangeJSON)
+	s.createAuditLog(ctx, actor, "RECORD_ERSS_EXCHANGE", exchangeID)
+	return nil
+}
+
+// ReadERSSExchange returns the ERSS exchange with the given ID
+func (s *SIHChaincode) ReadERSSExchange(ctx contractapi.TransactionContextInterface, exchangeID string) (*ERSSExchangeDocument, error) {
+	exchangeJSON, err := s.readState(ctx, "erss_exchange", exchangeID)
+	if err != nil {
+		return nil, err
+	}
+	var exchange ERSSExchangeDocument
+	if err := json.Unmarshal(exchangeJSON, &exchange); err != nil {
+		return nil, err
+	}
+	if exchange.DocType != "erss_exchange" {
+		return nil, fmt.Errorf("%s is not an ERSS exchange", exchangeID)
+	}
+	return &exchange, nil
+}
+
+// GetERSSExchangesBySubject returns the exchanges about an SOS or incident, earliest first
+func (s *SIHChaincode) GetERSSExchangesBySubject(ctx contractapi.TransactionContextInterface, subjectID string) ([]*ERSSExchangeDocument, error) {
+	exchanges := []*ERSSExchangeDocument{}
+	err := forEachQueryResult(ctx, map[string]interface{}{"doc_type": "erss_exchange", "subject_id": subjectID}, func(value []byte) error {
+		var exchange ERSSExchangeDocument
+		if err := json.Unmarshal(value, &exchange); err != nil {
+			return err
+		}
+		exchanges = append(exchanges, &exchange)
+		return nil
+	})
+	sort.Slice(exchanges, func(i, j int) bool { return exchanges[i].ExchangedAt < exchanges[j].ExchangedAt })
+	return exchanges, err
+}
+
+// ========== CCTV MANIFEST OPERATIONS ==========
+
+// AnchorCCTVManifest records a CCTV export against an incident. Each clip must already be recorded
+// as evidence on the incident with the clip's hash, and fall within the export's window.
+func (s *SIHChaincode) AnchorCCTVManifest(ctx contractapi.TransactionContextInterface, manifestID, incidentID, source, cameraID, windowStart, windowEnd, clipsJSON, manifestHash, actor string) error {
+	if manifestID == "" || source == "" || cameraID == "" || manifestHash == "" {
+		return fmt.Errorf("manifest %q must be complete", manifestID)
+	}
+	start, err := time.Parse(time.RFC3339, windowStart)
+	if err != nil {
+		return fmt.Errorf("invalid window start %q", windowStart)
+	}
+	end, err := time.Parse(time.RFC3339, windowEnd)
+	if err != nil || !end.After(start) {
+		return fmt.Errorf("invalid window end %q", windowEnd)
+	}
+	var clips []CCTVClip
+	if err := json.Unmarshal([]byte(clipsJSON), &clips); err != nil {
+		return fmt.Errorf("invalid clips: %w", err)
+	}
+	if len(clips) == 0 {
+		return fmt.Errorf("a manifest must list at least one clip")
+	}
+	incident, err := s.ReadIncident(ctx, incidentID)
+	if err != nil {
+		return err
+	}
+	seen := map[string]bool{}
+	for _, clip := range clips {
+		if clip.ClipID == "" || seen[clip.ClipID] {
+			return fmt.Errorf("clip IDs must be present and unique, got %q", clip.ClipID)
+		}
+		seen[clip.ClipID] = true
+		clipStart, err := time.Parse(time.RFC3339, clip.StartedAt)
+		if err != nil || clipStart.Before(start) {
+			return fmt.Errorf("clip %s starts outside the window", clip.ClipID)
+		}
+		clipEnd, err := time.Parse(time.RFC3339, clip.EndedAt)
+		if err != nil || clipEnd.Before(clipStart) || clipEnd.After(end) {
+			return fmt.Errorf("clip %s ends outside the window", clip.ClipID)
+		}
+		evidence, err := s.ReadEvidence(ctx, clip.EvidenceID)
+		if err != nil {
+			return err
+		}
+		if evidence.IncidentID != incidentID || evidence.EvidenceHash != clip.Hash {
+			return fmt.Errorf("the evidence %s is not clip %s", clip.EvidenceID, clip.ClipID)
+		}
+	}
+	existing, err := s.readState(ctx, "cctv_manifest", manifestID)
+	if err == nil && existing != nil {
+		return fmt.Errorf("the manifest %s already exists", manifestID)
+	}
+
+	anchoredAt, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	manifest := CCTVManifestDocument{
+		DocType:      "cctv_manifest",
+		ManifestID:   manifestID,
+		IncidentID:   incidentID,
+		Source:       source,
+		CameraID:     cameraID,
+		WindowStart:  windowStart,
+		WindowEnd:    windowEnd,
+		Clips:        clips,
+		ManifestHash: manifestHash,
+		AnchoredBy:   actor,
+		AnchoredAt:   anchoredAt,
+		OwnerOrg:     incident.OwnerOrg,
+		TxID:         ctx.GetStub().GetTxID(),
+	}
+	manifestJSON, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	if err := putState(ctx, "cctv_manifest", manifestID, manifestJSON); err != nil {
+		return err
+	}
+	ctx.GetStub().SetEvent("AnchorCCTVManifest", manifestJSON)
+	s.createAuditLog(ctx, actor, "ANCHOR_CCTV_MANIFEST", manifestID)
+	return nil
+}
+
+// ReadCCTVManifest returns the CCTV manifest with the given ID
+func (s *SIHChaincode) ReadCCTVManifest(ctx contractapi.TransactionContextInterface, manifestID string) (*CCTVManifestDocument, error) {
+	manifestJSON, err := s.readState(ctx, "cctv_manifest", manifestID)
+	if err != nil {
+		return nil, err
+	}
+	var manifest CCTVManifestDocument
+	if err := json.Unmarshal(manifestJSON, &manifest); err != nil {
+		return nil, err
+	}
+	if manifest.DocType != "cctv_manifest" {
+		return nil, fmt.Errorf("%s is not a CCTV manifest", manifestID)
+	}
+	return &manifest, nil
+}
+
+// GetCCTVManifestsByIncident returns the CCTV manifests anchored against an incident, by window start
+func (s *SIHChaincode) GetCCTVManifestsByIncident(ctx contractapi.TransactionContextInterface, incidentID string) ([]*CCTVManifestDocument, error) {
+	manifests := []*CCTVManifestDocument{}
+	err := forEachQueryResult(ctx, map[string]interface{}{"doc_type": "cctv_manifest", "incident_id": incidentID}, func(value []byte) error {
+		var manifest CCTVManifestDocument
+		if err := json.Unmarshal(value, &manifest); err != nil {
+			return err
+		}
+		manifests = append(manifests, &manifest)
+		return nil
+	})
+	sort.Slice(manifests, func(i, j int) bool { return manifests[i].WindowStart < manifests[j].WindowStart })
+	return manifests, err
+}
+
+// ========== ERASURE OPERATIONS ==========
+
+// RecordErasure records that a DID's off-chain personal data was erased. manifestJSON is the list
+// of categories purged; officer verification must name the officer.
+func (s *SIHChaincode) RecordErasure(ctx contractapi.TransactionContextInterface, erasureID, digitalID, reason, verification, verifiedBy, manifestJSON, actor string) error {
+	methods, ok := erasureVerifications[reason]
+	if !ok {
+		return fmt.Errorf("invalid erasure reason %q", reason)
+	}
+	if !slices.Contains(methods, verification) {
+		return fmt.Errorf("invalid verification %q for an erasure by %s", verification, reason)
+	}
+	if verification == "officer" && verifiedBy == "" {
+		return fmt.Errorf("officer verification must name the officer")
+	}
+	if erasureID == "" || digitalID == "" {
+		return fmt.Errorf("erasure %q must name a DID", erasureID)
+	}
+	var manifest []ErasureEntry
+	if err := json.Unmarshal([]byte(manifestJSON), &manifest); err != nil || len(manifest) == 0 {
+		return fmt.Errorf("invalid erasure manifest")
+	}
+	for _, entry := range manifest {
+		if entry.Category == "" || entry.Purged < 0 {
+			return fmt.Errorf("invalid erasure manifest entry %+v", entry)
+		}
+	}
+	existing, err := s.readState(ctx, "erasure", erasureID)
+	if err == nil && existing != nil {
+		return fmt.Errorf("the erasure %s already exists", erasureID)
+	}
+
+	sum := sha256.Sum256([]byte(manifestJSON))
+	erasedAt, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	erasure := ErasureDocument{
+		DocType:      "erasure",
+		ErasureID:    erasureID,
+		DigitalID:    digitalID,
+		Reason:       reason,
+		Verification: verification,
+		VerifiedBy:   verifiedBy,
+		Manifest:     manifest,
+		ManifestHash: hex.EncodeToString(sum[:]),
+		ErasedBy:     actor,
+		ErasedAt:     erasedAt,
+		TxID:         ctx.GetStub().GetTxID(),
+	}
+	erasureJSON, err := json.Marshal(erasure)
+	if err != nil {
+		return err
+	}
+	if err := putState(ctx, "erasure", erasureID, erasureJSON); err != nil {
+		return err
+	}
+	ctx.GetStub().SetEvent("RecordErasure", erasureJSON)
+	s.createAuditLog(ctx, actor, "RECORD_ERASURE", erasureID)
+	return nil
+}
+
+// ReadErasure returns the erasure record with the given ID
+func (s *SIHChaincode) ReadErasure(ctx contractapi.TransactionContextInterface, erasureID string) (*ErasureDocument, error) {
+	erasureJSON, err := s.readState(ctx, "erasure", erasureID)
+	if err != nil {
+		return nil, err
+	}
+	var erasure ErasureDocument
+	if err := json.Unmarshal(erasureJSON, &erasure); err != nil {
+		return nil, err
+	}
+	if erasure.DocType != "erasure" {
+		return nil, fmt.Errorf("%s is not an erasure record", erasureID)
+	}
+	return &erasure, nil
+}
+
+// GetErasures returns every erasure recorded for a DID
+func (s *SIHChaincode) GetErasures(ctx contractapi.TransactionContextInterface, digitalID string) ([]*ErasureDocument, error) {
+	erasures := []*ErasureDocument{}
+	err := forEachQueryResult(ctx, map[string]interface{}{"doc_type": "erasure", "digital_id": digitalID}, func(value []byte) error {
+		var erasure ErasureDocument
+		if err := json.Unmarshal(value, &erasure); err != nil {
+			return err
+		}
+		erasures = append(erasures, &erasure)
+		return nil
+	})
+	return erasures, err
+}
+
+// ========== API AUDIT OPERATIONS ==========
+
+// APIAuditEntry is one mutation handled by the gateway's REST or gRPC API
+type APIAuditEntry struct {
+	RequestID   string `json:"request_id"`
+	Actor       string `json:"actor"`
+	Method      string `json:"method"`
+	Route       string `json:"route"`
+	TargetID    string `json:"target_id"`
+	RequestHash string `json:"request_hash"`
+	Status      string `json:"status"`
+	ReceivedAt  string `json:"received_at"`
+}
+
+const maxAPIAuditsPerTx = 500
+
+var apiAuditMethods = map[string]bool{"POST": true, "PUT": true, "PATCH": true, "DELETE": true}
+
+// RecordAPIAudits records a batch of API mutations as audit entries with action API_<method>,
+// keyed by request ID. Entries already recorded by a retried batch are skipped, and the number
+// recorded is returned.
+func (s *SIHChaincode) RecordAPIAudits(ctx contractapi.TransactionContextInterface, entriesJSON string) (int, error) {
+	var entries []APIAuditEntry
+	if err := json.Unmarshal([]byte(entriesJSON), &entries); err != nil {
+		return 0, fmt.Errorf("invalid API audit entries: %v", err)
+	}
+	if len(entries) == 0 || len(entries) > maxAPIAuditsPerTx {
+		return 0, fmt.Errorf("entries must contain between 1 and %d API audit entries", maxAPIAuditsPerTx)
+	}
+
+	txID := ctx.GetStub().GetTxID()
+	submitter := submitterID(ctx)
+	recorded := []AuditDocument{}
+	for _, entry := range entries {
+		if entry.RequestID == "" || entry.Actor == "" || entry.Route == "" || !apiAuditMethods[entry.Method] {
+			return 0, fmt.Errorf("API audit entry %q must have a request ID, actor, route and mutating method", entry.RequestID)
+		}
+		if len(entry.RequestHash) != 64 || strings.Trim(entry.RequestHash, "0123456789abcdef") != "" {
+			return 0, fmt.Errorf("API audit entry %s must have a hex SHA-256 request hash", entry.RequestID)
+		}
+		receivedAt, err := time.Parse(time.RFC3339, entry.ReceivedAt)
+		if err != nil {
+			return 0, fmt.Errorf("API audit entry %s has an invalid received_at: %v", entry.RequestID, err)
+		}
+
+		auditID := "audit_api_" + entry.RequestID
+		existing, err := s.readState(ctx, "audit", auditID)
+		if err == nil && existing != nil {
+			continue
+		}
+		audit := AuditDocument{
+			DocType:   "audit",
+			AuditHash: entry.RequestHash,
+			Actor:     entry.Actor,
+			Action:    "API_" + entry.Method,
+			TargetID:  entry.TargetID,
+			Timestamp: receivedAt.UTC().Format(time.RFC3339),
+			TxID:      txID,
+			Submitter: submitter,
+			Route:     entry.Route,
+			RequestID: entry.RequestID,
+			Status:    entry.Status,
+		}
+		auditJSON, err := json.Marshal(audit)
+		if err != nil {
+			return 0, err
+		}
+		if err := putState(ctx, "audit", auditID, auditJSON); err != nil {
+			return 0, err
+		}
+		recorded = append(recorded, audit)
+	}
+
+	if len(recorded) > 0 {
+		eventJSON, err := json.Marshal(recorded)
+		if err != nil {
+			return 0, err
+		}
+		ctx.GetStub().SetEvent("RecordAPIAudits", eventJSON)
+	}
+	return len(recorded), nil
+}
+
+// ========== ZONE REGISTRY OPERATIONS ==========
+
+// CreateZone registers a geofence zone
+func (s *SIHChaincode) CreateZone(ctx contractapi.TransactionContextInterface, zoneID, name, geometry, riskLevel, activeFrom, activeTo, timezone, actor string) error {
+	existing, err := s.readState(ctx, "zone", zoneID)
+	if err == nil && existing != nil {
+		return fmt.Errorf("the zone %s already exists", zoneID)
+	}
+	return s.putZone(ctx, "CreateZone", "CREATE_ZONE", zoneID, name, geometry, riskLevel, activeFrom, activeTo, timezone, actor)
+}
+
+// UpdateZone replaces a geofence zone's name, geometry, risk level and hours
+func (s *SIHChaincode) UpdateZone(ctx contractapi.TransactionContextInterface, zoneID, name, geometry, riskLevel, activeFrom, activeTo, timezone, actor string) error {
+	if _, err := s.ReadZone(ctx, zoneID); err != nil {
+		return err
+	}
+	return s.putZone(ctx, "UpdateZone", "UPDATE_ZONE", zoneID, name, geometry, riskLevel, activeFrom, activeTo, timezone, actor)
+}
+
+// putZone checks the parts of a zone the chaincode can; the gateway validates the polygons themselves
+func (s *SIHChaincode) putZone(ctx contractapi.TransactionContextInterface, eventName, action, zoneID, name, geometry, riskLevel, activeFrom, activeTo, timezone, actor string) error {
+	if !zoneRiskLevels[riskLevel] {
+		return fmt.Errorf("invalid risk level %q", riskLevel)
+	}
+	var shape struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal([]byte(geometry), &shape); err != nil || (shape.Type != "Polygon" && shape.Type != "MultiPolygon") {
+		return fmt.Errorf("the geometry of zone %s must be a GeoJSON Polygon or MultiPolygon", zoneID)
+	}
+	if (activeFrom == "") != (activeTo == "") {
+		return fmt.Errorf("the zone %s must have both or neither of active_from and active_to", zoneID)
+	}
+
+	updatedAt, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	zone := ZoneDocument{
+		DocType:    "zone",
+		ZoneID:     zoneID,
+		Name:       name,
+		Geometry:   geometry,
+		RiskLevel:  riskLevel,
+		ActiveFrom: activeFrom,
+		ActiveTo:   activeTo,
+		Timezone:   timezone,
+		UpdatedBy:  actor,
+		UpdatedAt:  updatedAt,
+		TxID:       ctx.GetStub().GetTxID(),
+	}
+	if existing, err := s.ReadZone(ctx, zoneID); err == nil {
+		zone.RiskScore, zone.ScoredRiskLevel = existing.RiskScore, existing.ScoredRiskLevel
+		zone.ScoredIncidents, zone.ScoredAt = existing.ScoredIncidents, existing.ScoredAt
+	}
+
+	zoneJSON, err := json.Marshal(zone)
+	if err != nil {
+		return err
+	}
+
+	err = putState(ctx, "zone", zoneID, zoneJSON)
+	if err != nil {
+		return err
+	}
+
+	ctx.GetStub().SetEvent(eventName, zoneJSON)
+	s.createAuditLog(ctx, actor, action, zoneID)
+	return nil
+}
+
+// ReadZone returns the geofence zone with the given ID
+func (s *SIHChaincode) ReadZone(ctx contractapi.TransactionContextInterface, zoneID string) (*ZoneDocument, error) {
+	zoneJSON, err := s.readState(ctx, "zone", zoneID)
+	if err != nil {
+		return nil, err
+	}
+
+	var zone ZoneDocument
+	err = json.Unmarshal(zoneJSON, &zone)
+	if err != nil {
+		return nil, err
+	}
+	if zone.DocType != "zone" {
+		return nil, fmt.Errorf("the zone %s does not exist", zoneID)
+	}
+
+	return &zone, nil
+}
+
+// DeleteZone removes a geofence zone
+func (s *SIHChaincode) DeleteZone(ctx contractapi.TransactionContextInterface, zoneID, actor string) error {
+	if _, err := s.ReadZone(ctx, zoneID); err != nil {
+		return err
+	}
+	zoneJSON, err := s.readState(ctx, "zone", zoneID)
+	if err != nil {
+		return err
+	}
+
+	err = delState(ctx, "zone", zoneID)
+	if err != nil {
+		return err
+	}
+
+	ctx.GetStub().SetEvent("DeleteZone", zoneJSON)
+	s.createAuditLog(ctx, actor, "DELETE_ZONE", zoneID)
+	return nil
+}
+
+const maxZoneScoresPerTx = 500
+
+// ScoreZones records the risk scores the gateway computed for zones. Zones deleted since they were
+// scored are skipped; the number of zones updated is returned.
+func (s *SIHChaincode) ScoreZones(ctx contractapi.TransactionContextInterface, scoresJSON string) (int, error) {
+	var scores []ZoneScore
+	if err := json.Unmarshal([]byte(scoresJSON), &scores); err != nil {
+		return 0, fmt.Errorf("invalid scores: %v", err)
+	}
+	if len(scores) == 0 || len(scores) > maxZoneScoresPerTx {
+		return 0, fmt.Errorf("scores must contain between 1 and %d entries", maxZoneScoresPerTx)
+	}
+
+	var scored []*ZoneDocument
+	for _, score := range scores {
+		if score.RiskScore < 0 || score.RiskScore > 1 || !zoneRiskLevels[score.ScoredRiskLevel] || score.ScoredIncidents < 0 || score.ScoredAt == "" {
+			return 0, fmt.Errorf("the score for zone %q must be between 0 and 1, with a risk level, an incident count and a time", score.ZoneID)
+		}
+		zone, err := s.ReadZone(ctx, score.ZoneID)
+		if err != nil {
+			continue
+		}
+		zone.RiskScore, zone.ScoredRiskLevel = score.RiskScore, score.ScoredRiskLevel
+		zone.ScoredIncidents, zone.ScoredAt = score.ScoredIncidents, score.ScoredAt
+		zone.TxID = ctx.GetStub().GetTxID()
+		zoneJSON, err := json.Marshal(zone)
+		if err != nil {
+			return 0, err
+		}
+		if err := putState(ctx, "zone", zone.ZoneID, zoneJSON); err != nil {
+			return 0, err
+		}
+		scored = append(scored, zone)
+	}
+
+	if len(scored) > 0 {
+		eventJSON, err := json.Marshal(scored)
+		if err != nil {
+			return 0, err
+		}
+		ctx.GetStub().SetEvent("ScoreZones", eventJSON)
+	}
+	return len(scored), nil
+}
+
+// GetAllZones returns every registered geofence zone
+func (s *SIHChaincode) GetAllZones(ctx contractapi.TransactionContextInterface) ([]*ZoneDocument, error) {
+	zones := []*ZoneDocument{}
+	err := forEachQueryResult(ctx, map[string]interface{}{"doc_type": "zone"}, func(value []byte) error {
+		var zone ZoneDocument
+		if err := json.Unmarshal(value, &zone); err != nil {
+			return err
+		}
+		zones = append(zones, &zone)
+		return nil
+	})
+	return zones, err
+}
+
+// ========== KEY SCHEME MIGRATION OPERATIONS ==========
+
+// LegacyDocument is a document a v2 contract stored under its raw ID
+type LegacyDocument struct {
+	Key     string `json:"key"`
+	DocType string `json:"doc_type"`
+	ID      string `json:"id"`
+	// Hash is the SHA-256 of the stored value, which the move must preserve
+	Hash string `json:"hash"`
+}
+
+// LegacyDocumentPage is a page of ListLegacyDocuments; Next is where the following page starts,
+// or empty after the last
+type LegacyDocumentPage struct {
+	Documents []*LegacyDocument `json:"documents"`
+	Scanned   int               `json:"scanned"`
+	Next      string            `json:"next"`
+}
+
+// DocumentMigration is the outcome of moving one raw key
+type DocumentMigration struct {
+	Key     string `json:"key"`
+	DocType string `json:"doc_type,omitempty"`
+	ID      string `json:"id,omitempty"`
+	Hash    string `json:"hash,omitempty"`
+	// Status is migrated, missing when nothing is stored under the key, not_document when the
+	// value is not a document, or conflict when the namespaced key is already taken
+	Status string `json:"status"`
+}
+
+// DocumentDigest reports where a document is stored and the SHA-256 of its value
+type DocumentDigest struct {
+	DocType    string `json:"doc_type"`
+	ID         string `json:"id"`
+	Namespaced bool   `json:"namespaced"`
+	Legacy     bool   `json:"legacy"`
+	Hash       string `json:"hash,omitempty"`
+}
+
+const maxDocumentsPerMigration = 100
+
+// ListLegacyDocuments lists the documents still stored under raw IDs, in key order from startKey.
+// Range queries skip composite keys, so the documents already moved are not scanned again.
+func (s *SIHChaincode) ListLegacyDocuments(ctx contractapi.TransactionContextInterface, startKey string, pageSize int32) (*LegacyDocumentPage, error) {
+	if pageSize <= 0 || pageSize > maxQueryPageSize {
+		return nil, fmt.Errorf("page size must be between 1 and %d", maxQueryPageSize)
+	}
+	resultsIterator, err := ctx.GetStub().GetStateByRange(startKey, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	page := &LegacyDocumentPage{Documents: []*LegacyDocument{}}
+	for resultsIterator.HasNext() {
+		kv, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		if len(page.Documents) == int(pageSize) {
+			page.Next = kv.Key
+			break
+		}
+		page.Scanned++
+		docType := documentType(kv.Value)
+		if !exportDocTypes[docType] {
+			continue
+		}
+		page.Documents = append(page.Documents, &LegacyDocument{
+			Key:     kv.Key,
+			DocType: docType,
+			ID:      legacyDocumentID(docType, kv.Key),
+			Hash:    documentHash(kv.Value),
+		})
+	}
+	return page, nil
+}
+
+// MigrateLegacyDocuments moves documents from raw keys to their namespaced keys, byte for byte, so
+// their tx_id still names the transaction that last changed them. One MigrateLegacyDocuments
+// event lists the outcomes; indexers leave the moved documents as they are.
+func (s *SIHChaincode) MigrateLegacyDocuments(ctx contractapi.TransactionContextInterface, keysJSON, actor string) ([]*DocumentMigration, error) {
+	var keys []string
+	if err := json.Unmarshal([]byte(keysJSON), &keys); err != nil {
+		return nil, fmt.Errorf("invalid keys: %v", err)
+	}
+	if len(keys) == 0 || len(keys) > maxDocumentsPerMigration {
+		return nil, fmt.Errorf("keys must contain between 1 and %d entries", maxDocumentsPerMigration)
+	}
+	if actor == "" {
+		return nil, fmt.Errorf("actor cannot be empty")
+	}
+
+	migrations := []*DocumentMigration{}
+	seen := map[string]bool{}
+	for _, key := range keys {
+		if seen[key] {
+			return nil, fmt.Errorf("the key %s is listed more than once", key)
+		}
+		seen[key] = true
+		migration := &DocumentMigration{Key: key}
+		migrations = append(migrations, migration)
+
+		value, err := ctx.GetStub().GetState(key)
+		if err != nil {
+			return nil, err
+		}
+		if value == nil {
+			migration.Status = "missing"
+			continue
+		}
+		migration.DocType = documentType(value)
+		if !exportDocTypes[migration.DocType] {
+			migration.DocType, migration.Status = "", "not_document"
+			continue
+		}
+		migration.ID, migration.Hash = legacyDocumentID(migration.DocType, key), documentHash(value)
+		namespacedKey, err := documentKey(ctx, migration.DocType, migration.ID)
+		if err != nil {
+			return nil, err
+		}
+		existing, err := ctx.GetStub().GetState(namespacedKey)
+		if err != nil {
+			return nil, err
+		}
+		if existing != nil {
+			migration.Status = "conflict"
+			continue
+		}
+		if err := ctx.GetStub().PutState(namespacedKey, value); err != nil {
+			return nil, err
+		}
+		if err := ctx.GetStub().DelState(key); err != nil {
+			return nil, err
+		}
+		migration.Status = "migrated"
+	}
+
+	migrationsJSON, err := json.Marshal(migrations)
+	if err != nil {
+		return nil, err
+	}
+	ctx.GetStub().SetEvent("MigrateLegacyDocuments", migrationsJSON)
+	s.createAuditLog(ctx, actor, "MIGRATE_DOCUMENTS", ctx.GetStub().GetTxID())
+	return migrations, nil
+}
+
+// GetDocumentDigest reports whether a document is under its namespaced key, its raw ID or both,
+// and hashes the copy reads return
+func (s *SIHChaincode) GetDocumentDigest(ctx contractapi.TransactionContextInterface, docType, id string) (*DocumentDigest, error) {
+	if !exportDocTypes[docType] {
+		return nil, fmt.Errorf("unknown document type %q", docType)
+	}
+	key, err := documentKey(ctx, docType, id)
+	if err != nil {
+		return nil, err
+	}
+	value, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	legacy, err := getLegacyState(ctx, docType, id)
+	if err != nil {
+		return nil, err
+	}
+	digest := &DocumentDigest{DocType: docType, ID: id, Namespaced: value != nil, Legacy: legacy != nil}
+	if value == nil {
+		value = legacy
+	}
+	if value != nil {
+		digest.Hash = documentHash(value)
+	}
+	return digest, nil
+}
+
+func documentHash(value []byte) string {
+	sum := sha256.Sum256(value)
+	return hex.EncodeToString(sum[:])
+}
+
+// ========== QUERY OPERATIONS ==========
+
+// GetEvidenceByIncident returns all evidence related to a specific incident
+func (s *SIHChaincode) GetEvidenceByIncident(ctx contractapi.TransactionContextInterface, incidentID string) ([]*EvidenceDocument, error) {
+	queryString := fmt.Sprintf(`{"selector":{"doc_type":"evidence","incident_id":"%s"}}`, incidentID)
 
 	resultsIterator, err := ctx.GetStub().GetQueryResult(queryString)
 	if err != nil {
@@ -458,6 +5366,123 @@ func (s *SIHChaincode) GetEvidenceByIncident(ctx contractapi.TransactionContextI
 	return evidenceList, nil
 }
 
+// evidenceSortKeys are the fields QueryEvidenceByIncident can order by; prefix one with "-" to
+// sort in descending order
+var evidenceSortKeys = map[string]func(*EvidenceDocument) string{
+	"created_at":  func(e *EvidenceDocument) string { return e.CreatedAt },
+	"media_type":  func(e *EvidenceDocument) string { return e.MediaType },
+	"uploaded_by": func(e *EvidenceDocument) string { return e.UploadedBy },
+}
+
+// QueryEvidenceByIncident returns the evidence of an incident with the given media type and uploader,
+// created in [from, to), ordered by sortBy. Empty arguments are not filtered on; an empty sortBy
+// orders oldest first.
+func (s *SIHChaincode) QueryEvidenceByIncident(ctx contractapi.TransactionContextInterface, incidentID, mediaType, uploadedBy, from, to, sortBy string) ([]*EvidenceDocument, error) {
+	if sortBy == "" {
+		sortBy = "created_at"
+	}
+	descending := strings.HasPrefix(sortBy, "-")
+	key, ok := evidenceSortKeys[strings.TrimPrefix(sortBy, "-")]
+	if !ok {
+		return nil, fmt.Errorf("cannot sort evidence by %s", sortBy)
+	}
+
+	createdAt := map[string]string{"$gt": ""}
+	if from != "" {
+		createdAt = map[string]string{"$gte": from}
+	}
+	if to != "" {
+		createdAt["$lt"] = to
+	}
+	selector := map[string]interface{}{"doc_type": "evidence", "incident_id": incidentID, "created_at": createdAt}
+	if mediaType != "" {
+		selector["media_type"] = mediaType
+	}
+	if uploadedBy != "" {
+		selector["uploaded_by"] = uploadedBy
+	}
+
+	queryJSON, err := json.Marshal(map[string]interface{}{
+		"selector":  selector,
+		"use_index": []string{"_design/indexEvidenceIncidentDoc", "indexEvidenceIncident"},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	evidenceList := []*EvidenceDocument{}
+	resultsIterator, err := ctx.GetStub().GetQueryResult(string(queryJSON))
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var evidence EvidenceDocument
+		if err := json.Unmarshal(queryResponse.Value, &evidence); err != nil {
+			return nil, err
+		}
+		evidenceList = append(evidenceList, &evidence)
+	}
+
+	// An incident holds few enough documents to order here, whichever field is asked for
+	sort.SliceStable(evidenceList, func(i, j int) bool {
+		a, b := evidenceList[i], evidenceList[j]
+		if descending {
+			a, b = b, a
+		}
+		if key(a) != key(b) {
+			return key(a) < key(b)
+		}
+		if a.CreatedAt != b.CreatedAt {
+			return a.CreatedAt < b.CreatedAt
+		}
+		return a.EvidenceID < b.EvidenceID
+	})
+	return evidenceList, nil
+}
+
+// GetOpenIncidentsBySubject returns the incidents about a tourist that are not yet resolved, newest
+// first
+func (s *SIHChaincode) GetOpenIncidentsBySubject(ctx contractapi.TransactionContextInterface, subjectID string) ([]*IncidentDocument, error) {
+	queryJSON, err := json.Marshal(map[string]interface{}{
+		"selector": map[string]interface{}{
+			"doc_type":   "incident",
+			"subject_id": subjectID,
+			"status":     map[string]interface{}{"$nin": []string{incidentStatusResolved, "closed"}},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetQueryResult(string(queryJSON))
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	incidents := []*IncidentDocument{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var incident IncidentDocument
+		if err := json.Unmarshal(queryResponse.Value, &incident); err != nil {
+			return nil, err
+		}
+		incidents = append(incidents, &incident)
+	}
+	sort.SliceStable(incidents, func(i, j int) bool { return incidents[i].CreatedAt > incidents[j].CreatedAt })
+	return incidents, nil
+}
+
 // GetAuditsByTarget returns all audit logs for a specific target ID
 func (s *SIHChaincode) GetAuditsByTarget(ctx contractapi.TransactionContextInterface, targetID string) ([]*AuditDocument, error) {
 	queryString := fmt.Sprintf(`{"selector":{"doc_type":"audit","target_id":"%s"}}`, targetID)
@@ -485,3 +5510,335 @@ func (s *SIHChaincode) GetAuditsByTarget(ctx contractapi.TransactionContextInter
 
 	return auditList, nil
 }
+
+// QueryIncidents returns incidents created in [from, to) that match the given status, severity and
+// reporter, newest first. Empty arguments are not filtered on; pass the returned bookmark to fetch
+// the next page.
+func (s *SIHChaincode) QueryIncidents(ctx contractapi.TransactionContextInterface, from, to, status, severity, reporter string, pageSize int32, bookmark string) (*IncidentQueryResult, error) {
+	return s.QueryIncidentsForOrg(ctx, from, to, status, severity, reporter, "", false, pageSize, bookmark)
+}
+
+// QueryIncidentsForOrg is QueryIncidents limited to the incidents owned by ownerOrg, also returning
+// incidents recorded before ownership was tracked when includeUnowned is set. An empty ownerOrg
+// matches every incident.
+func (s *SIHChaincode) QueryIncidentsForOrg(ctx contractapi.TransactionContextInterface, from, to, status, severity, reporter, ownerOrg string, includeUnowned bool, pageSize int32, bookmark string) (*IncidentQueryResult, error) {
+	if pageSize <= 0 || pageSize > maxQueryPageSize {
+		return nil, fmt.Errorf("page size must be between 1 and %d", maxQueryPageSize)
+	}
+
+	createdAt := map[string]string{"$gt": ""}
+	if from != "" {
+		createdAt = map[string]string{"$gte": from}
+	}
+	if to != "" {
+		createdAt["$lt"] = to
+	}
+	selector := map[string]interface{}{"doc_type": "incident", "created_at": createdAt}
+	if status != "" {
+		selector["status"] = status
+	}
+	if severity != "" {
+		selector["severity"] = severity
+	}
+	if reporter != "" {
+		selector["reporter"] = reporter
+	}
+	if ownerOrg != "" {
+		addOwnerSelector(selector, "owner_org", ownerOrg, includeUnowned)
+	}
+
+	queryJSON, err := json.Marshal(map[string]interface{}{
+		"selector":  selector,
+		"sort":      []map[string]string{{"doc_type": "desc"}, {"created_at": "desc"}},
+		"use_index": []string{"_design/indexIncidentCreatedAtDoc", "indexIncidentCreatedAt"},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, metadata, err := ctx.GetStub().GetQueryResultWithPagination(string(queryJSON), pageSize, bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	result := &IncidentQueryResult{Incidents: []*IncidentDocument{}}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var incident IncidentDocument
+		err = json.Unmarshal(queryResponse.Value, &incident)
+		if err != nil {
+			return nil, err
+		}
+		result.Incidents = append(result.Incidents, &incident)
+	}
+
+	result.Bookmark = metadata.GetBookmark()
+	result.FetchedCount = metadata.GetFetchedRecordsCount()
+	return result, nil
+}
+
+// QueryAudits returns audit logs recorded in [from, to) for the given actor and action, newest
+// first. Empty arguments are not filtered on; pass the returned bookmark to fetch the next page.
+func (s *SIHChaincode) QueryAudits(ctx contractapi.TransactionContextInterface, actor, action, from, to string, pageSize int32, bookmark string) (*AuditQueryResult, error) {
+	return s.QueryAuditsForOrg(ctx, actor, action, from, to, "", false, pageSize, bookmark)
+}
+
+// QueryAuditsForOrg is QueryAudits limited to the audit logs of transactions submitted by
+// submitterOrg, also returning logs recorded before submitters were tracked when includeUnowned is
+// set. An empty submitterOrg matches every audit log.
+func (s *SIHChaincode) QueryAuditsForOrg(ctx contractapi.TransactionContextInterface, actor, action, from, to, submitterOrg string, includeUnowned bool, pageSize int32, bookmark string) (*AuditQueryResult, error) {
+	if pageSize <= 0 || pageSize > maxQueryPageSize {
+		return nil, fmt.Errorf("page size must be between 1 and %d", maxQueryPageSize)
+	}
+
+	timestamp := map[string]string{"$gt": ""}
+	if from != "" {
+		timestamp = map[string]string{"$gte": from}
+	}
+	if to != "" {
+		timestamp["$lt"] = to
+	}
+	selector := map[string]interface{}{"doc_type": "audit", "timestamp": timestamp}
+	if actor != "" {
+		selector["actor"] = actor
+	}
+	if action != "" {
+		selector["action"] = action
+	}
+	if submitterOrg != "" {
+		// Submitters are "<mspid>/<common name>", or the bare MSP ID without a certificate
+		owner := map[string]string{"$regex": "^" + regexp.QuoteMeta(submitterOrg) + "(/|$)"}
+		addOwnerSelector(selector, "submitter", owner, includeUnowned)
+	}
+
+	queryJSON, err := json.Marshal(map[string]interface{}{
+		"selector":  selector,
+		"sort":      []map[string]string{{"doc_type": "desc"}, {"timestamp": "desc"}},
+		"use_index": []string{"_design/indexAuditTimestampDoc", "indexAuditTimestamp"},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, metadata, err := ctx.GetStub().GetQueryResultWithPagination(string(queryJSON), pageSize, bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	result := &AuditQueryResult{Audits: []*AuditDocument{}}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var audit AuditDocument
+		err = json.Unmarshal(queryResponse.Value, &audit)
+		if err != nil {
+			return nil, err
+		}
+		result.Audits = append(result.Audits, &audit)
+	}
+
+	result.Bookmark = metadata.GetBookmark()
+	result.FetchedCount = metadata.GetFetchedRecordsCount()
+	return result, nil
+}
+
+// DocumentPage is one page of documents exactly as stored, for bulk extraction
+type DocumentPage struct {
+	Documents    []string `json:"documents"`
+	Bookmark     string   `json:"bookmark"`
+	FetchedCount int32    `json:"fetched_count"`
+}
+
+var exportDocTypes = map[string]bool{
+	"did": true, "incident": true, "evidence": true, "efir": true, "timeline_annexure": true, "incident_archive": true, "sla_breach": true, "sos": true,
+	"location_anchor": true, "safety_anchor": true, "assignment": true, "fir_allocation": true, "consent": true, "consent_receipt": true, "itinerary_anchor": true, "advisory_anchor": true, "report_anchor": true, "erasure": true, "zone": true, "stay": true, "permit": true, "claim_reference": true, "lost_found_item": true, "lost_found_match": true, "case_anchor": true, "device": true, "device_binding": true, "call": true, "cctv_manifest": true, "sos_trigger": true, "access_grant": true, "broadcast": true, "model_decision": true, "erss_exchange": true, "evidence_derivative": true, "audit": true,
+}
+
+// ExportDocuments returns a page of every document of docType in key order, so auditors can
+// extract a complete dataset; pass the returned bookmark to fetch the next page
+func (s *SIHChaincode) ExportDocuments(ctx contractapi.TransactionContextInterface, docType string, pageSize int32, bookmark string) (*DocumentPage, error) {
+	if !exportDocTypes[docType] {
+		return nil, fmt.Errorf("unknown document type %q", docType)
+	}
+	if pageSize <= 0 || pageSize > maxQueryPageSize {
+		return nil, fmt.Errorf("page size must be between 1 and %d", maxQueryPageSize)
+	}
+
+	queryJSON, err := json.Marshal(map[string]interface{}{
+		"selector":  map[string]string{"doc_type": docType},
+		"use_index": []string{"_design/indexDocTypeDoc", "indexDocType"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	resultsIterator, metadata, err := ctx.GetStub().GetQueryResultWithPagination(string(queryJSON), pageSize, bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	page := &DocumentPage{Documents: []string{}}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		page.Documents = append(page.Documents, string(queryResponse.Value))
+	}
+	page.Bookmark = metadata.GetBookmark()
+	page.FetchedCount = metadata.GetFetchedRecordsCount()
+	return page, nil
+}
+
+// addOwnerSelector restricts a query to documents whose field matches owner, or that have no such
+// field when includeUnowned is set
+func addOwnerSelector(selector map[string]interface{}, field string, owner interface{}, includeUnowned bool) {
+	if !includeUnowned {
+		selector[field] = owner
+		return
+	}
+	selector["$or"] = []map[string]interface{}{
+		{field: owner},
+		{field: map[string]bool{"$exists": false}},
+	}
+}
+
+// forEachQueryResult runs a rich query and passes every matching document to fn
+func forEachQueryResult(ctx contractapi.TransactionContextInterface, selector map[string]interface{}, fn func([]byte) error) error {
+	queryJSON, err := json.Marshal(map[string]interface{}{"selector": selector})
+	if err != nil {
+		return err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetQueryResult(string(queryJSON))
+	if err != nil {
+		return err
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return err
+		}
+		if err := fn(queryResponse.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetDashboardStats counts open incidents by severity, incidents acknowledged since ackSince and
+// their total time to acknowledge, SOS alerts, DIDs and evidence recorded since recentSince, and
+// DIDs expiring in [now, expiringBefore). All bounds are RFC3339 UTC timestamps; the scans are
+// unpaginated, so callers are expected to cache the result.
+func (s *SIHChaincode) GetDashboardStats(ctx contractapi.TransactionContextInterface, recentSince, ackSince, now, expiringBefore string) (*DashboardStats, error) {
+	nowTime, err := time.Parse(time.RFC3339, now)
+	if err != nil {
+		return nil, fmt.Errorf("invalid now timestamp: %w", err)
+	}
+	expiringTime, err := time.Parse(time.RFC3339, expiringBefore)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expiringBefore timestamp: %w", err)
+	}
+	stats := &DashboardStats{OpenIncidentsBySeverity: map[string]int{}}
+
+	openSelector := map[string]interface{}{
+		"doc_type": "incident",
+		"$or": []map[string]interface{}{
+			{"status": map[string]interface{}{"$in": []string{incidentStatusOpen, incidentStatusAcknowledged}}},
+			{"status": map[string]bool{"$exists": false}},
+		},
+	}
+	err = forEachQueryResult(ctx, openSelector, func(value []byte) error {
+		var incident IncidentDocument
+		if err := json.Unmarshal(value, &incident); err != nil {
+			return err
+		}
+		severity := incident.Severity
+		if severity == "" {
+			severity = "unclassified"
+		}
+		stats.OpenIncidentsBySeverity[severity]++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	acknowledgedSelector := map[string]interface{}{"doc_type": "incident", "acknowledged_at": map[string]string{"$gte": ackSince}}
+	err = forEachQueryResult(ctx, acknowledgedSelector, func(value []byte) error {
+		var incident IncidentDocument
+		if err := json.Unmarshal(value, &incident); err != nil {
+			return err
+		}
+		created, err := time.Parse(time.RFC3339, incident.CreatedAt)
+		if err != nil {
+			return nil
+		}
+		acknowledged, err := time.Parse(time.RFC3339, incident.AcknowledgedAt)
+		if err != nil || acknowledged.Before(created) {
+			return nil
+		}
+		stats.AcknowledgedIncidents++
+		stats.AcknowledgeSeconds += int64(acknowledged.Sub(created).Seconds())
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sosSelector := map[string]interface{}{"doc_type": "audit", "action": "RAISE_SOS", "timestamp": map[string]string{"$gte": recentSince}}
+	err = forEachQueryResult(ctx, sosSelector, func([]byte) error {
+		stats.SOSAlerts++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = forEachQueryResult(ctx, map[string]interface{}{"doc_type": "did"}, func(value []byte) error {
+		var did DIDDocument
+		if err := json.Unmarshal(value, &did); err != nil {
+			return err
+		}
+		stats.DIDsIssued++
+		if did.IssuedAt >= recentSince {
+			stats.DIDsIssuedRecently++
+		}
+		// expires_at may carry any offset, so compare instants rather than strings
+		if expiresAt, err := time.Parse(time.RFC3339, did.ExpiresAt); err == nil && !expiresAt.Before(nowTime) && expiresAt.Before(expiringTime) {
+			stats.DIDsExpiring++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = forEachQueryResult(ctx, map[string]interface{}{"doc_type": "evidence"}, func(value []byte) error {
+		var evidence EvidenceDocument
+		if err := json.Unmarshal(value, &evidence); err != nil {
+			return err
+		}
+		stats.EvidenceCount++
+		if evidence.CreatedAt >= recentSince {
+			stats.EvidenceRecently++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,188 @@
+package chaincode
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/v2/shim"
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+	"github.com/hyperledger/fabric-protos-go-apiv2/ledger/queryresult"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// mockStub keeps world state and key history in memory. Calls it does not implement panic.
+type mockStub struct {
+	shim.ChaincodeStubInterface
+	txID      string
+	timestamp time.Time
+	state     map[string][]byte
+	history   map[string][]*queryresult.KeyModification
+}
+
+func newMockContext() (*contractapi.TransactionContext, *mockStub) {
+	stub := &mockStub{state: map[string][]byte{}, history: map[string][]*queryresult.KeyModification{}}
+	ctx := new(contractapi.TransactionContext)
+	ctx.SetStub(stub)
+	return ctx, stub
+}
+
+func (s *mockStub) GetTxID() string { return s.txID }
+
+func (s *mockStub) GetTxTimestamp() (*timestamppb.Timestamp, error) {
+	return timestamppb.New(s.timestamp), nil
+}
+
+func (s *mockStub) SetEvent(name string, payload []byte) error { return nil }
+
+func (s *mockStub) CreateCompositeKey(objectType string, attributes []string) (string, error) {
+	return shim.CreateCompositeKey(objectType, attributes)
+}
+
+func (s *mockStub) GetState(key string) ([]byte, error) { return s.state[key], nil }
+
+func (s *mockStub) PutState(key string, value []byte) error {
+	s.state[key] = value
+	s.history[key] = append(s.history[key], &queryresult.KeyModification{TxId: s.txID, Value: value})
+	return nil
+}
+
+func (s *mockStub) DelState(key string) error {
+	delete(s.state, key)
+	s.history[key] = append(s.history[key], &queryresult.KeyModification{TxId: s.txID, IsDelete: true})
+	return nil
+}
+
+func (s *mockStub) GetHistoryForKey(key string) (shim.HistoryQueryIteratorInterface, error) {
+	// Like the peer, newest first
+	modifications := slices.Clone(s.history[key])
+	slices.Reverse(modifications)
+	return &mockHistoryIterator{modifications: modifications}, nil
+}
+
+type mockHistoryIterator struct {
+	modifications []*queryresult.KeyModification
+}
+
+func (it *mockHistoryIterator) HasNext() bool { return len(it.modifications) > 0 }
+
+func (it *mockHistoryIterator) Close() error { return nil }
+
+func (it *mockHistoryIterator) Next() (*queryresult.KeyModification, error) {
+	next := it.modifications[0]
+	it.modifications = it.modifications[1:]
+	return next, nil
+}
+
+func TestDocumentKeyMigration(t *testing.T) {
+	ctx, stub := newMockContext()
+	id := "did:sih:tourist1"
+	legacy := []byte(`{"doc_type":"did","digital_id":"did:sih:tourist1","issuer":"v2"}`)
+
+	// A v2 contract stored the document under its raw ID
+	stub.txID = "tx-1"
+	if err := stub.PutState(id, legacy); err != nil {
+		t.Fatal(err)
+	}
+	value, err := getState(ctx, "did", id)
+	if err != nil || !bytes.Equal(value, legacy) {
+		t.Fatalf("expected the legacy document read through its raw ID, got %s, %v", value, err)
+	}
+	if value, _ := getState(ctx, "incident", id); value != nil {
+		t.Errorf("expected a raw ID holding another type ignored, got %s", value)
+	}
+
+	// The next write moves it under its namespaced key
+	stub.txID = "tx-2"
+	updated := []byte(`{"doc_type":"did","digital_id":"did:sih:tourist1","issuer":"v3"}`)
+	if err := putState(ctx, "did", id, updated); err != nil {
+		t.Fatal(err)
+	}
+	key, _ := documentKey(ctx, "did", id)
+	if !bytes.Equal(stub.state[key], updated) {
+		t.Errorf("expected the document under %q, got %s", key, stub.state[key])
+	}
+	if _, ok := stub.state[id]; ok {
+		t.Error("expected the raw ID removed once the document moved")
+	}
+	if value, _ := getState(ctx, "did", id); !bytes.Equal(value, updated) {
+		t.Errorf("expected the moved document read back, got %s", value)
+	}
+
+	stub.txID = "tx-3"
+	if err := delState(ctx, "did", id); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := getState(ctx, "did", id); value != nil || len(stub.state) != 0 {
+		t.Errorf("expected the document deleted, got %s and state %v", value, stub.state)
+	}
+
+	// History runs from the raw ID into the namespaced key, without the raw ID's removal by the move
+	history, err := documentHistory(ctx, "did", id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, modification := range history {
+		entry := modification.txID
+		if modification.deleted {
+			entry += " deleted"
+		}
+		got = append(got, entry)
+	}
+	if want := []string{"tx-1", "tx-2", "tx-3 deleted"}; !slices.Equal(got, want) {
+		t.Errorf("got history %v, want %v", got, want)
+	}
+	if !bytes.Equal(history[0].value, legacy) || !bytes.Equal(history[1].value, updated) {
+		t.Errorf("expected each version's value in the history, got %+v", history)
+	}
+}
+
+func TestDocumentHistoryIgnoresOtherTypes(t *testing.T) {
+	ctx, stub := newMockContext()
+	stub.txID = "tx-1"
+	if err := stub.PutState("A-1", []byte(`{"doc_type":"incident","incident_id":"A-1"}`)); err != nil {
+		t.Fatal(err)
+	}
+	stub.txID = "tx-2"
+	if err := putState(ctx, "sos", "A-1", []byte(`{"doc_type":"sos","sos_id":"A-1"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := stub.state["A-1"]; !ok {
+		t.Error("expected a raw ID holding another type left alone")
+	}
+	history, err := documentHistory(ctx, "sos", "A-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 1 || history[0].txID != "tx-2" {
+		t.Errorf("expected only the SOS alert's own history, got %+v", history)
+	}
+}
+
+func TestAllocateFIRNumberUsesTransactionYear(t *testing.T) {
+	ctx, stub := newMockContext()
+	contract := new(SIHChaincode)
+	if err := putState(ctx, "incident", "INC-1", []byte(`{"doc_type":"incident","incident_id":"INC-1"}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every endorsing peer numbers the FIR from the client's timestamp, whatever its own clock says
+	stub.txID, stub.timestamp = "tx-1", time.Date(2025, time.December, 31, 23, 59, 59, 0, time.UTC)
+	allocation, err := contract.AllocateFIRNumber(ctx, "FIR-1", "INC-1", "East Khasi Hills", "Sadar", "officer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allocation.FIRNumber != "0001/2025" || allocation.AllocatedAt != "2025-12-31T23:59:59Z" {
+		t.Errorf("expected the FIR numbered in the transaction's year, got %+v", allocation)
+	}
+
+	stub.txID, stub.timestamp = "tx-2", stub.timestamp.Add(time.Second)
+	if allocation, err = contract.AllocateFIRNumber(ctx, "FIR-2", "INC-1", "East Khasi Hills", "Sadar", "officer"); err != nil {
+		t.Fatal(err)
+	}
+	if allocation.FIRNumber != "0001/2026" {
+		t.Errorf("expected the register to restart with the transaction's new year, got %s", allocation.FIRNumber)
+	}
+}
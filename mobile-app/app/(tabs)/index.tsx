@@ -13,6 +13,7 @@ import { LinearGradient } from 'expo-linear-gradient';
 import { TriangleAlert as AlertTriangle, MapPin, CreditCard, Navigation, Shield, Phone, User, Clock } from 'lucide-react-native';
 import { SafetyGauge } from '@/components/SafetyGauge';
 import { SOSButton } from '@/components/SOSButton';
+import { SOSCountdown } from '@/components/SOSCountdown';
 import { QuickActionCard } from '@/components/QuickActionCard';
 import { LocationDisplay } from '@/components/LocationDisplay';
 
@@ -45,23 +46,21 @@ export default function HomeScreen() {
     return 'High Risk';
   };
 
+  const [sosCountdown, setSOSCountdown] = useState(false);
+
   const handleSOSPress = () => {
     Vibration.vibrate([0, 500, 200, 500]);
-    Alert.alert(
-      '🚨 Emergency SOS',
-      'Are you sure you want to trigger emergency alert?',
-      [
-        { text: 'Cancel', style: 'cancel' },
-        { 
-          text: 'YES - Send SOS', 
-          style: 'destructive',
-          onPress: () => {
-            // Trigger SOS sequence
-            Alert.alert('SOS Activated', 'Help is on the way!\n\nNotifying:\n• Emergency Services (112)\n• Tourist Helpline\n• Your emergency contacts');
-          }
-        }
-      ]
-    );
+    setSOSCountdown(true);
+  };
+
+  const handleSOSCancel = () => {
+    setSOSCountdown(false);
+    Alert.alert('SOS Cancelled', 'No alert was sent.');
+  };
+
+  const handleSOSConfirm = () => {
+    setSOSCountdown(false);
+    Alert.alert('SOS Activated', 'Help is on the way!\n\nNotifying:\n• Emergency Services (112)\n• Tourist Helpline\n• Your emergency contacts');
   };
 
   const quickActions = [
@@ -135,6 +134,7 @@ export default function HomeScreen() {
       <View style={styles.sosSection}>
         <SOSButton onPress={handleSOSPress} />
         <Text style={styles.sosHint}>Press and hold for 3 seconds</Text>
+        <SOSCountdown visible={sosCountdown} onCancel={handleSOSCancel} onConfirm={handleSOSConfirm} />
       </View>
 
       {/* Quick Actions */}
@@ -0,0 +1,119 @@
+import React, { useEffect, useState } from 'react';
+import { Modal, View, Text, TouchableOpacity, StyleSheet, Vibration } from 'react-native';
+import { TriangleAlert as AlertTriangle } from 'lucide-react-native';
+
+interface SOSCountdownProps {
+  visible: boolean;
+  seconds?: number;
+  onCancel: () => void;
+  onConfirm: () => void;
+}
+
+// SOSCountdown gives the tourist a window to cancel an accidental SOS. The alert is sent when the
+// countdown ends unless it is cancelled first.
+export function SOSCountdown({ visible, seconds = 15, onCancel, onConfirm }: SOSCountdownProps) {
+  const [remaining, setRemaining] = useState(seconds);
+
+  useEffect(() => {
+    if (!visible) return;
+    const interval = setInterval(() => {
+      setRemaining(current => current - 1);
+    }, 1000);
+    // Reset on close, so the next trigger starts a full countdown
+    return () => {
+      clearInterval(interval);
+      setRemaining(seconds);
+    };
+  }, [visible, seconds]);
+
+  useEffect(() => {
+    if (!visible) return;
+    if (remaining <= 0) {
+      onConfirm();
+      return;
+    }
+    Vibration.vibrate(100);
+  }, [visible, remaining]);
+
+  return (
+    <Modal visible={visible} transparent animationType="fade" onRequestClose={onCancel}>
+      <View style={styles.overlay}>
+        <View style={styles.card}>
+          <AlertTriangle size={40} color="#EF4444" />
+          <Text style={styles.title}>Sending SOS</Text>
+          <Text style={styles.countdown}>{Math.max(remaining, 0)}</Text>
+          <Text style={styles.message}>
+            Emergency services and your contacts will be alerted when the countdown ends.
+          </Text>
+          <TouchableOpacity style={styles.cancelButton} onPress={onCancel}>
+            <Text style={styles.cancelText}>Cancel - I'm safe</Text>
+          </TouchableOpacity>
+          <TouchableOpacity style={styles.confirmButton} onPress={onConfirm}>
+            <Text style={styles.confirmText}>Send now</Text>
+          </TouchableOpacity>
+        </View>
+      </View>
+    </Modal>
+  );
+}
+
+const styles = StyleSheet.create({
+  overlay: {
+    flex: 1,
+    backgroundColor: 'rgba(0, 0, 0, 0.6)',
+    alignItems: 'center',
+    justifyContent: 'center',
+    padding: 24,
+  },
+  card: {
+    width: '100%',
+    backgroundColor: '#FFFFFF',
+    borderRadius: 16,
+    padding: 24,
+    alignItems: 'center',
+  },
+  title: {
+    fontSize: 20,
+    fontWeight: '700',
+    color: '#1F2937',
+    marginTop: 12,
+  },
+  countdown: {
+    fontSize: 64,
+    fontWeight: '800',
+    color: '#EF4444',
+    marginVertical: 8,
+  },
+  message: {
+    fontSize: 14,
+    color: '#6B7280',
+    textAlign: 'center',
+    marginBottom: 24,
+  },
+  cancelButton: {
+    width: '100%',
+    backgroundColor: '#10B981',
+    borderRadius: 12,
+    paddingVertical: 14,
+    alignItems: 'center',
+    marginBottom: 12,
+  },
+  cancelText: {
+    fontSize: 16,
+    fontWeight: '700',
+    color: '#FFFFFF',
+  },
+  confirmButton: {
+    width: '100%',
+    borderWidth: 1,
+    borderColor: '#EF4444',
+    borderRadius: 12,
+    paddingVertical: 14,
+    alignItems: 'center',
+  },
+  confirmText: {
+    fontSize: 16,
+    fontWeight: '600',
+    color: '#EF4444',
+  },
+});